@@ -0,0 +1,57 @@
+#!/bin/sh
+
+# Copyright 2021 The gVisor Authors.
+#
+# Licensed under the Apache License, Version 2.0 (the "License");
+# you may not use this file except in compliance with the License.
+# You may obtain a copy of the License at
+#
+#     http://www.apache.org/licenses/LICENSE-2.0
+#
+# Unless required by applicable law or agreed to in writing, software
+# distributed under the License is distributed on an "AS IS" BASIS,
+# WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+# See the License for the specific language governing permissions and
+# limitations under the License.
+
+# Starts dockerd in the sandbox and uses it to run or build a container. The
+# sandbox has no bridge or NAT support, so containers use the host network.
+
+set -eu
+
+dockerd --bridge=none --iptables=false --ip6tables=false \
+  --storage-driver=overlay2 > /var/log/dockerd.log 2>&1 &
+
+for i in $(seq 60); do
+  if docker info > /dev/null 2>&1; then
+    break
+  fi
+  sleep 1
+done
+if ! docker info > /dev/null 2>&1; then
+  echo "dockerd didn't start:"
+  cat /var/log/dockerd.log
+  exit 1
+fi
+
+tar -C /rootfs -c . | docker import - nested/busybox > /dev/null
+
+case "${1:-run}" in
+  run)
+    docker run --rm --network=host nested/busybox echo nested container OK
+    ;;
+  build)
+    printf 'FROM nested/busybox\nRUN echo nested container OK > /out\n' | \
+      DOCKER_BUILDKIT=0 docker build -q --network=host -t nested/built - > /dev/null
+    docker run --rm --network=host nested/built cat /out
+    ;;
+  buildkit)
+    printf 'FROM nested/busybox\nRUN echo nested container OK > /out\n' | \
+      DOCKER_BUILDKIT=1 docker build -q --network=host -t nested/built - > /dev/null
+    docker run --rm --network=host nested/built cat /out
+    ;;
+  *)
+    echo "unknown mode $1"
+    exit 1
+    ;;
+esac
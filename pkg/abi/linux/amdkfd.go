@@ -0,0 +1,37 @@
+// Copyright 2021 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package linux
+
+// Sizes of ioctl arguments, from uapi/linux/kfd_ioctl.h.
+const (
+	// SizeOfKFDGetVersionArgs is the size of struct
+	// kfd_ioctl_get_version_args.
+	SizeOfKFDGetVersionArgs = 8
+
+	// SizeOfKFDGetClockCountersArgs is the size of struct
+	// kfd_ioctl_get_clock_counters_args.
+	SizeOfKFDGetClockCountersArgs = 40
+
+	// SizeOfKFDGetAvailableMemoryArgs is the size of struct
+	// kfd_ioctl_get_available_memory_args.
+	SizeOfKFDGetAvailableMemoryArgs = 16
+)
+
+// ioctl(2) request numbers from uapi/linux/kfd_ioctl.h, for /dev/kfd.
+var (
+	AMDKFD_IOC_GET_VERSION        = IOC(_IOC_READ, 'K', 0x01, SizeOfKFDGetVersionArgs)
+	AMDKFD_IOC_GET_CLOCK_COUNTERS = IOC(_IOC_READ|_IOC_WRITE, 'K', 0x05, SizeOfKFDGetClockCountersArgs)
+	AMDKFD_IOC_AVAILABLE_MEMORY   = IOC(_IOC_READ|_IOC_WRITE, 'K', 0x23, SizeOfKFDGetAvailableMemoryArgs)
+)
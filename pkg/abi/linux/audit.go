@@ -21,3 +21,53 @@ const (
 	// AUDIT_ARCH_AARCH64 identifies ARM64.
 	AUDIT_ARCH_AARCH64 = 0xc00000b7
 )
+
+// Audit netlink message types, from <uapi/linux/audit.h>.
+const (
+	AUDIT_GET        = 1000
+	AUDIT_SET        = 1001
+	AUDIT_USER       = 1005
+	AUDIT_ADD_RULE   = 1011
+	AUDIT_DEL_RULE   = 1012
+	AUDIT_LIST_RULES = 1013
+
+	// AUDIT_FIRST_USER_MSG to AUDIT_LAST_USER_MSG and AUDIT_FIRST_USER_MSG2
+	// to AUDIT_LAST_USER_MSG2 are the ranges of messages that userspace may
+	// send to be logged.
+	AUDIT_FIRST_USER_MSG  = 1100
+	AUDIT_LAST_USER_MSG   = 1199
+	AUDIT_FIRST_USER_MSG2 = 2100
+	AUDIT_LAST_USER_MSG2  = 2999
+)
+
+// Audit record types, from <uapi/linux/audit.h>.
+const (
+	AUDIT_SYSCALL  = 1300
+	AUDIT_SOCKADDR = 1306
+	AUDIT_EXECVE   = 1309
+	AUDIT_EOE      = 1320
+)
+
+// Audit status mask bits, from <uapi/linux/audit.h>.
+const (
+	AUDIT_STATUS_ENABLED       = 0x1
+	AUDIT_STATUS_FAILURE       = 0x2
+	AUDIT_STATUS_PID           = 0x4
+	AUDIT_STATUS_RATE_LIMIT    = 0x8
+	AUDIT_STATUS_BACKLOG_LIMIT = 0x10
+)
+
+// AuditStatus is struct audit_status, from <uapi/linux/audit.h>.
+type AuditStatus struct {
+	Mask                  uint32
+	Enabled               uint32
+	Failure               uint32
+	PID                   uint32
+	RateLimit             uint32
+	BacklogLimit          uint32
+	Lost                  uint32
+	Backlog               uint32
+	FeatureBitmap         uint32
+	BacklogWaitTime       uint32
+	BacklogWaitTimeActual uint32
+}
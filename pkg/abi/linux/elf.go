@@ -100,9 +100,18 @@ const (
 	// NT_PRFPREG is for float point register.
 	NT_PRFPREG = 0x2
 
+	// NT_PRPSINFO is for process information, struct elf_prpsinfo.
+	NT_PRPSINFO = 0x3
+
+	// NT_AUXV is for the auxiliary vector.
+	NT_AUXV = 0x6
+
 	// NT_X86_XSTATE is for x86 extended state using xsave.
 	NT_X86_XSTATE = 0x202
 
 	// NT_ARM_TLS is for ARM TLS register.
 	NT_ARM_TLS = 0x401
+
+	// NT_ARM_SVE is for ARM Scalable Vector Extension registers.
+	NT_ARM_SVE = 0x405
 )
@@ -170,3 +170,9 @@ var (
 	ERFKILL         = &Errno{132, "operation not possible due to RF-kill"}
 	EHWPOISON       = &Errno{133, "memory page has hardware error"}
 )
+
+// Errno values from include/linux/errno.h, which are internal to the kernel
+// but leak to userspace from some syscalls.
+var (
+	ENOTSUPP = &Errno{524, "operation is not supported"}
+)
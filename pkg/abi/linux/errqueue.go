@@ -20,12 +20,19 @@ import (
 
 // Socket error origin codes as defined in include/uapi/linux/errqueue.h.
 const (
-	SO_EE_ORIGIN_NONE  = 0
-	SO_EE_ORIGIN_LOCAL = 1
-	SO_EE_ORIGIN_ICMP  = 2
-	SO_EE_ORIGIN_ICMP6 = 3
+	SO_EE_ORIGIN_NONE         = 0
+	SO_EE_ORIGIN_LOCAL        = 1
+	SO_EE_ORIGIN_ICMP         = 2
+	SO_EE_ORIGIN_ICMP6        = 3
+	SO_EE_ORIGIN_TIMESTAMPING = 4
+	SO_EE_ORIGIN_ZEROCOPY     = 5
 )
 
+// SO_EE_CODE_ZEROCOPY_COPIED is the ee_code of a SO_EE_ORIGIN_ZEROCOPY
+// notification for sends whose data was copied rather than sent in place,
+// from include/uapi/linux/errqueue.h.
+const SO_EE_CODE_ZEROCOPY_COPIED = 1
+
 // SockExtendedErr represents struct sock_extended_err in Linux defined in
 // include/uapi/linux/errqueue.h.
 //
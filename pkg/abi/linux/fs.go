@@ -19,13 +19,17 @@ package linux
 // See linux/magic.h.
 const (
 	ANON_INODE_FS_MAGIC   = 0x09041934
+	CGROUP2_SUPER_MAGIC   = 0x63677270
 	DEVPTS_SUPER_MAGIC    = 0x00001cd1
+	EROFS_SUPER_MAGIC_V1  = 0xe0f5e1e2
 	EXT_SUPER_MAGIC       = 0xef53
+	NFS_SUPER_MAGIC       = 0x6969
 	OVERLAYFS_SUPER_MAGIC = 0x794c7630
 	PIPEFS_MAGIC          = 0x50495045
 	PROC_SUPER_MAGIC      = 0x9fa0
 	RAMFS_MAGIC           = 0x09041934
 	SOCKFS_MAGIC          = 0x534F434B
+	SQUASHFS_MAGIC        = 0x73717368
 	SYSFS_MAGIC           = 0x62656572
 	TMPFS_MAGIC           = 0x01021994
 	V9FS_MAGIC            = 0x01021997
@@ -103,3 +107,9 @@ const (
 	RENAME_EXCHANGE  = (1 << 1) // Exchange src and dst.
 	RENAME_WHITEOUT  = (1 << 2) // Whiteout src.
 )
+
+// Whiteout device, from include/linux/fs.h.
+const (
+	WHITEOUT_MODE = 0
+	WHITEOUT_DEV  = 0
+)
@@ -0,0 +1,148 @@
+// Copyright 2020 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package linux
+
+// Encryption policy versions from uapi/linux/fscrypt.h.
+const (
+	FSCRYPT_POLICY_V1 = 0
+	FSCRYPT_POLICY_V2 = 2
+)
+
+// Encryption modes from uapi/linux/fscrypt.h.
+const (
+	FSCRYPT_MODE_AES_256_XTS = 1
+	FSCRYPT_MODE_AES_256_CTS = 4
+	FSCRYPT_MODE_AES_128_CBC = 5
+	FSCRYPT_MODE_AES_128_CTS = 6
+	FSCRYPT_MODE_ADIANTUM    = 9
+)
+
+// Encryption policy flags from uapi/linux/fscrypt.h.
+const (
+	FSCRYPT_POLICY_FLAGS_PAD_MASK      = 0x03
+	FSCRYPT_POLICY_FLAG_DIRECT_KEY     = 0x04
+	FSCRYPT_POLICY_FLAG_IV_INO_LBLK_64 = 0x08
+	FSCRYPT_POLICY_FLAG_IV_INO_LBLK_32 = 0x10
+)
+
+// Sizes from uapi/linux/fscrypt.h.
+const (
+	FSCRYPT_KEY_DESCRIPTOR_SIZE = 8
+	FSCRYPT_KEY_IDENTIFIER_SIZE = 16
+	FSCRYPT_FILE_NONCE_SIZE     = 16
+	FSCRYPT_MAX_KEY_SIZE        = 64
+)
+
+// Key specifier types from uapi/linux/fscrypt.h.
+const (
+	FSCRYPT_KEY_SPEC_TYPE_DESCRIPTOR = 1
+	FSCRYPT_KEY_SPEC_TYPE_IDENTIFIER = 2
+)
+
+// Key removal status flags from uapi/linux/fscrypt.h.
+const (
+	FSCRYPT_KEY_REMOVAL_STATUS_FLAG_FILES_BUSY  = 0x1
+	FSCRYPT_KEY_REMOVAL_STATUS_FLAG_OTHER_USERS = 0x2
+)
+
+// Key statuses from uapi/linux/fscrypt.h.
+const (
+	FSCRYPT_KEY_STATUS_ABSENT               = 1
+	FSCRYPT_KEY_STATUS_PRESENT              = 2
+	FSCRYPT_KEY_STATUS_INCOMPLETELY_REMOVED = 3
+
+	FSCRYPT_KEY_STATUS_FLAG_ADDED_BY_SELF = 0x1
+)
+
+// FscryptPolicyV1 is struct fscrypt_policy_v1 from uapi/linux/fscrypt.h.
+//
+// +marshal
+type FscryptPolicyV1 struct {
+	Version                 uint8
+	ContentsEncryptionMode  uint8
+	FilenamesEncryptionMode uint8
+	Flags                   uint8
+	MasterKeyDescriptor     [FSCRYPT_KEY_DESCRIPTOR_SIZE]byte
+}
+
+// FscryptPolicyV2 is struct fscrypt_policy_v2 from uapi/linux/fscrypt.h.
+//
+// +marshal
+type FscryptPolicyV2 struct {
+	Version                 uint8
+	ContentsEncryptionMode  uint8
+	FilenamesEncryptionMode uint8
+	Flags                   uint8
+	Reserved                [4]uint8
+	MasterKeyIdentifier     [FSCRYPT_KEY_IDENTIFIER_SIZE]byte
+}
+
+// FscryptKeySpecifier is struct fscrypt_key_specifier from
+// uapi/linux/fscrypt.h.
+//
+// U holds the key descriptor or key identifier, depending on Type.
+//
+// +marshal
+type FscryptKeySpecifier struct {
+	Type     uint32
+	Reserved uint32
+	U        [32]byte
+}
+
+// FscryptAddKeyArg is struct fscrypt_add_key_arg from uapi/linux/fscrypt.h,
+// without the trailing raw key, which is RawSize bytes long.
+//
+// +marshal
+type FscryptAddKeyArg struct {
+	KeySpec  FscryptKeySpecifier
+	RawSize  uint32
+	KeyID    uint32
+	Reserved [8]uint32
+}
+
+// FscryptRemoveKeyArg is struct fscrypt_remove_key_arg from
+// uapi/linux/fscrypt.h.
+//
+// +marshal
+type FscryptRemoveKeyArg struct {
+	KeySpec            FscryptKeySpecifier
+	RemovalStatusFlags uint32
+	Reserved           [5]uint32
+}
+
+// FscryptGetKeyStatusArg is struct fscrypt_get_key_status_arg from
+// uapi/linux/fscrypt.h.
+//
+// +marshal
+type FscryptGetKeyStatusArg struct {
+	KeySpec     FscryptKeySpecifier
+	Reserved    [6]uint32
+	Status      uint32
+	StatusFlags uint32
+	UserCount   uint32
+	Reserved2   [13]uint32
+}
+
+// Encryption ioctls from uapi/linux/fscrypt.h.
+var (
+	FS_IOC_SET_ENCRYPTION_POLICY           = IOC(_IOC_READ, 'f', 19, 12)
+	FS_IOC_GET_ENCRYPTION_POLICY           = IOC(_IOC_WRITE, 'f', 21, 12)
+	FS_IOC_GET_ENCRYPTION_POLICY_EX        = IOC(_IOC_READ|_IOC_WRITE, 'f', 22, 9)
+	FS_IOC_ADD_ENCRYPTION_KEY              = IOC(_IOC_READ|_IOC_WRITE, 'f', 23, 80)
+	FS_IOC_REMOVE_ENCRYPTION_KEY           = IOC(_IOC_READ|_IOC_WRITE, 'f', 24, 64)
+	FS_IOC_REMOVE_ENCRYPTION_KEY_ALL_USERS = IOC(_IOC_READ|_IOC_WRITE, 'f', 25, 64)
+	FS_IOC_GET_ENCRYPTION_KEY_STATUS       = IOC(_IOC_READ|_IOC_WRITE, 'f', 26, 128)
+	FS_IOC_GET_ENCRYPTION_NONCE            = IOC(_IOC_READ, 'f', 27, 16)
+)
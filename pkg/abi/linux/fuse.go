@@ -55,31 +55,56 @@ const (
 	FUSE_STATFS  = 17
 	FUSE_RELEASE = 18
 	_
-	FUSE_FSYNC        = 20
-	FUSE_SETXATTR     = 21
-	FUSE_GETXATTR     = 22
-	FUSE_LISTXATTR    = 23
-	FUSE_REMOVEXATTR  = 24
-	FUSE_FLUSH        = 25
-	FUSE_INIT         = 26
-	FUSE_OPENDIR      = 27
-	FUSE_READDIR      = 28
-	FUSE_RELEASEDIR   = 29
-	FUSE_FSYNCDIR     = 30
-	FUSE_GETLK        = 31
-	FUSE_SETLK        = 32
-	FUSE_SETLKW       = 33
-	FUSE_ACCESS       = 34
-	FUSE_CREATE       = 35
-	FUSE_INTERRUPT    = 36
-	FUSE_BMAP         = 37
-	FUSE_DESTROY      = 38
-	FUSE_IOCTL        = 39
-	FUSE_POLL         = 40
-	FUSE_NOTIFY_REPLY = 41
-	FUSE_BATCH_FORGET = 42
+	FUSE_FSYNC           = 20
+	FUSE_SETXATTR        = 21
+	FUSE_GETXATTR        = 22
+	FUSE_LISTXATTR       = 23
+	FUSE_REMOVEXATTR     = 24
+	FUSE_FLUSH           = 25
+	FUSE_INIT            = 26
+	FUSE_OPENDIR         = 27
+	FUSE_READDIR         = 28
+	FUSE_RELEASEDIR      = 29
+	FUSE_FSYNCDIR        = 30
+	FUSE_GETLK           = 31
+	FUSE_SETLK           = 32
+	FUSE_SETLKW          = 33
+	FUSE_ACCESS          = 34
+	FUSE_CREATE          = 35
+	FUSE_INTERRUPT       = 36
+	FUSE_BMAP            = 37
+	FUSE_DESTROY         = 38
+	FUSE_IOCTL           = 39
+	FUSE_POLL            = 40
+	FUSE_NOTIFY_REPLY    = 41
+	FUSE_BATCH_FORGET    = 42
+	FUSE_FALLOCATE       = 43
+	FUSE_READDIRPLUS     = 44
+	FUSE_RENAME2         = 45
+	FUSE_LSEEK           = 46
+	FUSE_COPY_FILE_RANGE = 47
+	FUSE_SETUPMAPPING    = 48
+	FUSE_REMOVEMAPPING   = 49
+	FUSE_SYNCFS          = 50
 )
 
+// FUSE notification codes, sent by the daemon in FUSEHeaderOut.Error with
+// FUSEHeaderOut.Unique set to 0.
+//
+// Analogous to enum fuse_notify_code in include/uapi/linux/fuse.h.
+const (
+	FUSE_NOTIFY_POLL        = 1
+	FUSE_NOTIFY_INVAL_INODE = 2
+	FUSE_NOTIFY_INVAL_ENTRY = 3
+	FUSE_NOTIFY_STORE       = 4
+	FUSE_NOTIFY_RETRIEVE    = 5
+	FUSE_NOTIFY_DELETE      = 6
+)
+
+// FUSE_INT_REQ_BIT is set in the unique ID of FUSE_INTERRUPT requests, and of
+// the daemon's replies to them.
+const FUSE_INT_REQ_BIT = 1 << 0
+
 const (
 	// FUSE_MIN_READ_BUFFER is the minimum size the read can be for any FUSE filesystem.
 	// This is the minimum size Linux supports. See linux.fuse.h.
@@ -131,33 +156,49 @@ type FUSEHeaderOut struct {
 }
 
 // FUSE_INIT flags, consistent with the ones in include/uapi/linux/fuse.h.
-// Our taget version is 7.23 but we have few implemented in advance.
+// Flags above bit 31 are sent in FUSEInitIn.Flags2 and FUSEInitOut.Flags2,
+// which are only valid if FUSE_INIT_EXT is set.
 const (
-	FUSE_ASYNC_READ       = 1 << 0
-	FUSE_POSIX_LOCKS      = 1 << 1
-	FUSE_FILE_OPS         = 1 << 2
-	FUSE_ATOMIC_O_TRUNC   = 1 << 3
-	FUSE_EXPORT_SUPPORT   = 1 << 4
-	FUSE_BIG_WRITES       = 1 << 5
-	FUSE_DONT_MASK        = 1 << 6
-	FUSE_SPLICE_WRITE     = 1 << 7
-	FUSE_SPLICE_MOVE      = 1 << 8
-	FUSE_SPLICE_READ      = 1 << 9
-	FUSE_FLOCK_LOCKS      = 1 << 10
-	FUSE_HAS_IOCTL_DIR    = 1 << 11
-	FUSE_AUTO_INVAL_DATA  = 1 << 12
-	FUSE_DO_READDIRPLUS   = 1 << 13
-	FUSE_READDIRPLUS_AUTO = 1 << 14
-	FUSE_ASYNC_DIO        = 1 << 15
-	FUSE_WRITEBACK_CACHE  = 1 << 16
-	FUSE_NO_OPEN_SUPPORT  = 1 << 17
-	FUSE_MAX_PAGES        = 1 << 22 // From FUSE 7.28
+	FUSE_ASYNC_READ          = 1 << 0
+	FUSE_POSIX_LOCKS         = 1 << 1
+	FUSE_FILE_OPS            = 1 << 2
+	FUSE_ATOMIC_O_TRUNC      = 1 << 3
+	FUSE_EXPORT_SUPPORT      = 1 << 4
+	FUSE_BIG_WRITES          = 1 << 5
+	FUSE_DONT_MASK           = 1 << 6
+	FUSE_SPLICE_WRITE        = 1 << 7
+	FUSE_SPLICE_MOVE         = 1 << 8
+	FUSE_SPLICE_READ         = 1 << 9
+	FUSE_FLOCK_LOCKS         = 1 << 10
+	FUSE_HAS_IOCTL_DIR       = 1 << 11
+	FUSE_AUTO_INVAL_DATA     = 1 << 12
+	FUSE_DO_READDIRPLUS      = 1 << 13
+	FUSE_READDIRPLUS_AUTO    = 1 << 14
+	FUSE_ASYNC_DIO           = 1 << 15
+	FUSE_WRITEBACK_CACHE     = 1 << 16
+	FUSE_NO_OPEN_SUPPORT     = 1 << 17
+	FUSE_PARALLEL_DIROPS     = 1 << 18
+	FUSE_HANDLE_KILLPRIV     = 1 << 19
+	FUSE_POSIX_ACL           = 1 << 20
+	FUSE_ABORT_ERROR         = 1 << 21
+	FUSE_MAX_PAGES           = 1 << 22 // From FUSE 7.28
+	FUSE_CACHE_SYMLINKS      = 1 << 23
+	FUSE_NO_OPENDIR_SUPPORT  = 1 << 24
+	FUSE_EXPLICIT_INVAL_DATA = 1 << 25
+	FUSE_MAP_ALIGNMENT       = 1 << 26
+	FUSE_SUBMOUNTS           = 1 << 27
+	FUSE_HANDLE_KILLPRIV_V2  = 1 << 28
+	FUSE_SETXATTR_EXT        = 1 << 29
+	FUSE_INIT_EXT            = 1 << 30 // From FUSE 7.36
+	FUSE_INIT_RESERVED       = 1 << 31
+	FUSE_SECURITY_CTX        = 1 << 32
+	FUSE_HAS_INODE_DAX       = 1 << 33
 )
 
 // currently supported FUSE protocol version numbers.
 const (
 	FUSE_KERNEL_VERSION       = 7
-	FUSE_KERNEL_MINOR_VERSION = 31
+	FUSE_KERNEL_MINOR_VERSION = 38
 )
 
 // Constants relevant to FUSE operations.
@@ -184,10 +225,16 @@ type FUSEInitIn struct {
 
 	// Flags of this init request.
 	Flags uint32
+
+	// Flags2 contains the upper 32 bits of the flags of this init request.
+	// Only valid if Flags contains FUSE_INIT_EXT.
+	Flags2 uint32
+
+	_ [11]uint32
 }
 
 // FUSEInitOut is the reply sent by the daemon to the kernel
-// for FUSEInitIn. We target FUSE 7.23; this struct supports 7.28.
+// for FUSEInitIn. We target FUSE 7.23; this struct supports 7.38.
 //
 // +marshal
 type FUSEInitOut struct {
@@ -228,9 +275,15 @@ type FUSEInitOut struct {
 	// if the value from daemon is too large.
 	MaxPages uint16
 
-	_ uint16
+	// MapAlignment is the log2 of the alignment of DAX mappings. Only valid
+	// if Flags contains FUSE_MAP_ALIGNMENT.
+	MapAlignment uint16
 
-	_ [8]uint32
+	// Flags2 contains the upper 32 bits of the flags of this init reply.
+	// Only valid if Flags contains FUSE_INIT_EXT.
+	Flags2 uint32
+
+	_ [7]uint32
 }
 
 // FUSE_GETATTR_FH is currently the only flag of FUSEGetAttrIn.GetAttrFlags.
@@ -877,3 +930,82 @@ func (r *FUSEUnlinkIn) MarshalBytes(buf []byte) {
 func (r *FUSEUnlinkIn) SizeBytes() int {
 	return len(r.Name) + 1
 }
+
+// FUSEInterruptIn is the request sent by the kernel to the daemon
+// to interrupt a request that is being processed.
+//
+// +marshal
+type FUSEInterruptIn struct {
+	// Unique is the unique identifier of the request to interrupt.
+	Unique FUSEOpID
+}
+
+// FUSELseekIn is the request sent by the kernel to the daemon
+// for FUSE_LSEEK.
+//
+// +marshal
+type FUSELseekIn struct {
+	// Fh is the file handle in userspace.
+	Fh uint64
+
+	// Offset is the offset to seek from.
+	Offset uint64
+
+	// Whence is SEEK_DATA or SEEK_HOLE.
+	Whence uint32
+
+	_ uint32
+}
+
+// FUSELseekOut is the reply sent by the daemon to the kernel
+// for FUSELseekIn.
+//
+// +marshal
+type FUSELseekOut struct {
+	// Offset is the resulting offset.
+	Offset uint64
+}
+
+// FUSE_POLL_SCHEDULE_NOTIFY is currently the only flag of FUSEPollIn.Flags.
+// If it is set, the daemon sends a FUSE_NOTIFY_POLL notification when the
+// readiness of the file changes.
+const FUSE_POLL_SCHEDULE_NOTIFY = (1 << 0)
+
+// FUSEPollIn is the request sent by the kernel to the daemon
+// for FUSE_POLL.
+//
+// +marshal
+type FUSEPollIn struct {
+	// Fh is the file handle in userspace.
+	Fh uint64
+
+	// Kh is the kernel's handle for the file, reported back in
+	// FUSENotifyPollWakeupOut.
+	Kh uint64
+
+	// Flags of this poll request.
+	Flags uint32
+
+	// Events is the set of requested events.
+	Events uint32
+}
+
+// FUSEPollOut is the reply sent by the daemon to the kernel
+// for FUSEPollIn.
+//
+// +marshal
+type FUSEPollOut struct {
+	// Revents is the set of ready events.
+	Revents uint32
+
+	_ uint32
+}
+
+// FUSENotifyPollWakeupOut is the payload of the FUSE_NOTIFY_POLL notification
+// sent by the daemon to the kernel.
+//
+// +marshal
+type FUSENotifyPollWakeupOut struct {
+	// Kh is the kernel's handle for the file, as sent in FUSEPollIn.Kh.
+	Kh uint64
+}
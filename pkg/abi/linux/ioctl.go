@@ -150,6 +150,23 @@ func IOC(dir, typ, nr, size uint32) uint32 {
 	return uint32(dir)<<_IOC_DIRSHIFT | typ<<_IOC_TYPESHIFT | nr<<_IOC_NRSHIFT | size<<_IOC_SIZESHIFT
 }
 
+// ioctl(2) directions, as returned by IOC_DIR.
+const (
+	IOC_NONE  = _IOC_NONE
+	IOC_WRITE = _IOC_WRITE
+	IOC_READ  = _IOC_READ
+)
+
+// IOC_DIR outputs the result of _IOC_DIR macro in asm-generic/ioctl.h.
+func IOC_DIR(request uint32) uint32 {
+	return (request >> _IOC_DIRSHIFT) & (1<<_IOC_DIRBITS - 1)
+}
+
+// IOC_SIZE outputs the result of _IOC_SIZE macro in asm-generic/ioctl.h.
+func IOC_SIZE(request uint32) uint32 {
+	return (request >> _IOC_SIZESHIFT) & (1<<_IOC_SIZEBITS - 1)
+}
+
 // Kcov ioctls from kernel/kcov.h.
 var (
 	KCOV_INIT_TRACE = IOC(_IOC_READ, 'c', 1, 8)
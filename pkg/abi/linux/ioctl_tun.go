@@ -16,14 +16,35 @@ package linux
 
 // ioctl(2) request numbers from linux/if_tun.h
 var (
-	TUNSETIFF = IOC(_IOC_WRITE, 'T', 202, 4)
-	TUNGETIFF = IOC(_IOC_READ, 'T', 210, 4)
+	TUNSETIFF       = IOC(_IOC_WRITE, 'T', 202, 4)
+	TUNSETPERSIST   = IOC(_IOC_WRITE, 'T', 203, 4)
+	TUNGETFEATURES  = IOC(_IOC_READ, 'T', 207, 4)
+	TUNSETOFFLOAD   = IOC(_IOC_WRITE, 'T', 208, 4)
+	TUNGETIFF       = IOC(_IOC_READ, 'T', 210, 4)
+	TUNGETVNETHDRSZ = IOC(_IOC_READ, 'T', 215, 4)
+	TUNSETVNETHDRSZ = IOC(_IOC_WRITE, 'T', 216, 4)
+	TUNSETQUEUE     = IOC(_IOC_WRITE, 'T', 217, 4)
 )
 
 // Flags from net/if_tun.h
 const (
-	IFF_TUN      = 0x0001
-	IFF_TAP      = 0x0002
-	IFF_NO_PI    = 0x1000
-	IFF_NOFILTER = 0x1000
+	IFF_TUN          = 0x0001
+	IFF_TAP          = 0x0002
+	IFF_MULTI_QUEUE  = 0x0100
+	IFF_ATTACH_QUEUE = 0x0200
+	IFF_DETACH_QUEUE = 0x0400
+	IFF_PERSIST      = 0x0800
+	IFF_NO_PI        = 0x1000
+	IFF_NOFILTER     = 0x1000
+	IFF_ONE_QUEUE    = 0x2000
+	IFF_VNET_HDR     = 0x4000
+)
+
+// Offload features for TUNSETOFFLOAD, from net/if_tun.h.
+const (
+	TUN_F_CSUM    = 0x01
+	TUN_F_TSO4    = 0x02
+	TUN_F_TSO6    = 0x04
+	TUN_F_TSO_ECN = 0x08
+	TUN_F_UFO     = 0x10
 )
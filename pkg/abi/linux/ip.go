@@ -32,6 +32,7 @@ const (
 	IPPROTO_GRE     = 47
 	IPPROTO_ESP     = 50
 	IPPROTO_AH      = 51
+	IPPROTO_ICMPV6  = 58
 	IPPROTO_MTP     = 92
 	IPPROTO_BEETPH  = 94
 	IPPROTO_ENCAP   = 98
@@ -0,0 +1,168 @@
+// Copyright 2021 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package linux
+
+// Socket options for SOL_IP multicast routing, from uapi/linux/mroute.h.
+const (
+	MRT_BASE          = 200
+	MRT_INIT          = MRT_BASE
+	MRT_DONE          = MRT_BASE + 1
+	MRT_ADD_VIF       = MRT_BASE + 2
+	MRT_DEL_VIF       = MRT_BASE + 3
+	MRT_ADD_MFC       = MRT_BASE + 4
+	MRT_DEL_MFC       = MRT_BASE + 5
+	MRT_VERSION       = MRT_BASE + 6
+	MRT_ASSERT        = MRT_BASE + 7
+	MRT_PIM           = MRT_BASE + 8
+	MRT_TABLE         = MRT_BASE + 9
+	MRT_ADD_MFC_PROXY = MRT_BASE + 10
+	MRT_DEL_MFC_PROXY = MRT_BASE + 11
+	MRT_FLUSH         = MRT_BASE + 12
+)
+
+// MRT_VERSION_VALUE is the value returned by getsockopt(MRT_VERSION), from
+// net/ipv4/ipmr.c.
+const MRT_VERSION_VALUE = 0x0305
+
+// MAXVIFS is the maximum number of virtual interfaces of an IPv4 multicast
+// router, from uapi/linux/mroute.h.
+const MAXVIFS = 32
+
+// Flags for VIFCtl.Flags, from uapi/linux/mroute.h.
+const (
+	VIFF_TUNNEL      = 0x1
+	VIFF_SRCRT       = 0x2
+	VIFF_REGISTER    = 0x4
+	VIFF_USE_IFINDEX = 0x8
+)
+
+// Message types of struct igmpmsg, from uapi/linux/mroute.h.
+const (
+	IGMPMSG_NOCACHE  = 1
+	IGMPMSG_WRONGVIF = 2
+	IGMPMSG_WHOLEPKT = 3
+)
+
+// VIFCtl is struct vifctl, from uapi/linux/mroute.h.
+//
+// LocalAddr holds vifc_lcl_ifindex instead of vifc_lcl_addr when Flags has
+// VIFF_USE_IFINDEX set.
+//
+// +marshal
+type VIFCtl struct {
+	VIFI       uint16
+	Flags      uint8
+	Threshold  uint8
+	RateLimit  uint32
+	LocalAddr  InetAddr
+	RemoteAddr InetAddr
+}
+
+// MFCCtl is struct mfcctl, from uapi/linux/mroute.h.
+//
+// +marshal
+type MFCCtl struct {
+	Origin   InetAddr
+	McastGrp InetAddr
+	Parent   uint16
+	TTLs     [MAXVIFS]uint8
+	_        [2]byte
+	PktCnt   uint32
+	ByteCnt  uint32
+	WrongIf  uint32
+	Expire   int32
+}
+
+// Socket options for SOL_IPV6 multicast routing, from uapi/linux/mroute6.h.
+const (
+	MRT6_BASE          = 200
+	MRT6_INIT          = MRT6_BASE
+	MRT6_DONE          = MRT6_BASE + 1
+	MRT6_ADD_MIF       = MRT6_BASE + 2
+	MRT6_DEL_MIF       = MRT6_BASE + 3
+	MRT6_ADD_MFC       = MRT6_BASE + 4
+	MRT6_DEL_MFC       = MRT6_BASE + 5
+	MRT6_VERSION       = MRT6_BASE + 6
+	MRT6_ASSERT        = MRT6_BASE + 7
+	MRT6_PIM           = MRT6_BASE + 8
+	MRT6_TABLE         = MRT6_BASE + 9
+	MRT6_ADD_MFC_PROXY = MRT6_BASE + 10
+	MRT6_DEL_MFC_PROXY = MRT6_BASE + 11
+	MRT6_FLUSH         = MRT6_BASE + 12
+)
+
+// MRT6_VERSION_VALUE is the value returned by getsockopt(MRT6_VERSION), from
+// net/ipv6/ip6mr.c.
+const MRT6_VERSION_VALUE = 0x0305
+
+// MAXMIFS is the maximum number of multicast interfaces of an IPv6 multicast
+// router, from uapi/linux/mroute6.h.
+const MAXMIFS = 32
+
+// Flags for MIF6Ctl.Flags, from uapi/linux/mroute6.h.
+const (
+	MIFF_REGISTER = 0x1
+)
+
+// Message types of struct mrt6msg, from uapi/linux/mroute6.h.
+const (
+	MRT6MSG_NOCACHE  = 1
+	MRT6MSG_WRONGMIF = 2
+	MRT6MSG_WHOLEPKT = 3
+)
+
+// SizeOfMRT6Msg is the size of struct mrt6msg, from uapi/linux/mroute6.h.
+const SizeOfMRT6Msg = 40
+
+// MIF6Ctl is struct mif6ctl, from uapi/linux/mroute6.h.
+//
+// +marshal
+type MIF6Ctl struct {
+	MIFI      uint16
+	Flags     uint8
+	Threshold uint8
+	PIFI      uint16
+	_         [2]byte
+	RateLimit uint32
+}
+
+// Constants for IFSet, from uapi/linux/mroute6.h.
+const (
+	IF_SETSIZE = 256
+	NIFBITS    = 32
+)
+
+// IFSet is struct if_set, from uapi/linux/mroute6.h.
+//
+// +marshal
+type IFSet struct {
+	Bits [IF_SETSIZE / NIFBITS]uint32
+}
+
+// IsSet returns true if the bit for mif is set.
+func (s *IFSet) IsSet(mif int) bool {
+	return s.Bits[mif/NIFBITS]&(1<<(mif%NIFBITS)) != 0
+}
+
+// MF6CCtl is struct mf6cctl, from uapi/linux/mroute6.h.
+//
+// +marshal
+type MF6CCtl struct {
+	Origin   SockAddrInet6
+	McastGrp SockAddrInet6
+	Parent   uint16
+	_        [2]byte
+	IFSet    IFSet
+}
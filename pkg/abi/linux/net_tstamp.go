@@ -0,0 +1,60 @@
+// Copyright 2021 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package linux
+
+// Flags for SO_TIMESTAMPING, from include/uapi/linux/net_tstamp.h.
+const (
+	SOF_TIMESTAMPING_TX_HARDWARE  = 1 << 0
+	SOF_TIMESTAMPING_TX_SOFTWARE  = 1 << 1
+	SOF_TIMESTAMPING_RX_HARDWARE  = 1 << 2
+	SOF_TIMESTAMPING_RX_SOFTWARE  = 1 << 3
+	SOF_TIMESTAMPING_SOFTWARE     = 1 << 4
+	SOF_TIMESTAMPING_SYS_HARDWARE = 1 << 5
+	SOF_TIMESTAMPING_RAW_HARDWARE = 1 << 6
+	SOF_TIMESTAMPING_OPT_ID       = 1 << 7
+	SOF_TIMESTAMPING_TX_SCHED     = 1 << 8
+	SOF_TIMESTAMPING_TX_ACK       = 1 << 9
+	SOF_TIMESTAMPING_OPT_CMSG     = 1 << 10
+	SOF_TIMESTAMPING_OPT_TSONLY   = 1 << 11
+	SOF_TIMESTAMPING_OPT_STATS    = 1 << 12
+	SOF_TIMESTAMPING_OPT_PKTINFO  = 1 << 13
+	SOF_TIMESTAMPING_OPT_TX_SWHW  = 1 << 14
+
+	SOF_TIMESTAMPING_LAST = SOF_TIMESTAMPING_OPT_TX_SWHW
+	SOF_TIMESTAMPING_MASK = SOF_TIMESTAMPING_LAST<<1 - 1
+
+	SOF_TIMESTAMPING_TX_RECORD_MASK = SOF_TIMESTAMPING_TX_HARDWARE | SOF_TIMESTAMPING_TX_SOFTWARE | SOF_TIMESTAMPING_TX_SCHED | SOF_TIMESTAMPING_TX_ACK
+)
+
+// Values of ee_info in a SO_EE_ORIGIN_TIMESTAMPING extended error, from
+// include/uapi/linux/errqueue.h.
+const (
+	SCM_TSTAMP_SND   = 0
+	SCM_TSTAMP_SCHED = 1
+	SCM_TSTAMP_ACK   = 2
+)
+
+// ControlMessageTimestamping is an SCM_TIMESTAMPING socket control message.
+//
+// ControlMessageTimestamping represents struct scm_timestamping from
+// include/uapi/linux/errqueue.h. Ts[0] holds the software timestamp and Ts[2]
+// the raw hardware timestamp; Ts[1] is deprecated and always zero.
+type ControlMessageTimestamping struct {
+	Ts [3]Timespec
+}
+
+// SizeOfControlMessageTimestamping is the size of an SCM_TIMESTAMPING control
+// message.
+const SizeOfControlMessageTimestamping = 3 * 16
@@ -376,6 +376,27 @@ type XTRedirectTarget struct {
 // SizeOfXTRedirectTarget is the size of an XTRedirectTarget.
 const SizeOfXTRedirectTarget = 56
 
+// XTTPROXYTargetInfoV1 corresponds to struct xt_tproxy_target_info_v1 in
+// include/uapi/linux/netfilter/xt_TPROXY.h.
+type XTTPROXYTargetInfoV1 struct {
+	MarkMask  uint32
+	MarkValue uint32
+	LAddr     Inet6Addr // union nf_inet_addr.
+	LPort     uint16    // Network byte order.
+	_         [2]byte
+}
+
+// XTTPROXYTarget diverts packets to a transparent proxy when reached.
+// Adding 4 bytes of padding to make the struct 8 byte aligned.
+type XTTPROXYTarget struct {
+	Target XTEntryTarget
+	Info   XTTPROXYTargetInfoV1
+	_      [4]byte
+}
+
+// SizeOfXTTPROXYTarget is the size of an XTTPROXYTarget.
+const SizeOfXTTPROXYTarget = 64
+
 // IPTGetinfo is the argument for the IPT_SO_GET_INFO sockopt. It corresponds
 // to struct ipt_getinfo in include/uapi/linux/netfilter_ipv4/ip_tables.h.
 //
@@ -0,0 +1,215 @@
+// Copyright 2021 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package linux
+
+// Netfilter netlink subsystems, from uapi/linux/netfilter/nfnetlink.h.
+const (
+	NFNL_SUBSYS_NONE              = 0
+	NFNL_SUBSYS_CTNETLINK         = 1
+	NFNL_SUBSYS_CTNETLINK_EXP     = 2
+	NFNL_SUBSYS_QUEUE             = 3
+	NFNL_SUBSYS_ULOG              = 4
+	NFNL_SUBSYS_OSF               = 5
+	NFNL_SUBSYS_IPSET             = 6
+	NFNL_SUBSYS_ACCT              = 7
+	NFNL_SUBSYS_CTNETLINK_TIMEOUT = 8
+	NFNL_SUBSYS_CTHELPER          = 9
+	NFNL_SUBSYS_NFTABLES          = 10
+	NFNL_SUBSYS_NFT_COMPAT        = 11
+)
+
+// NFNETLINK_V0 is the version of netfilter netlink messages, from
+// uapi/linux/netfilter/nfnetlink.h.
+const NFNETLINK_V0 = 0
+
+// NFNLSubsysID returns the subsystem of a netfilter netlink message type, from
+// uapi/linux/netfilter/nfnetlink.h.
+func NFNLSubsysID(typ uint16) uint16 {
+	return (typ & 0xff00) >> 8
+}
+
+// NFNLMsgType returns the message type in the subsystem of a netfilter netlink
+// message type, from uapi/linux/netfilter/nfnetlink.h.
+func NFNLMsgType(typ uint16) uint16 {
+	return typ & 0x00ff
+}
+
+// NFGenMsg is struct nfgenmsg, from uapi/linux/netfilter/nfnetlink.h.
+type NFGenMsg struct {
+	Family  uint8
+	Version uint8
+	// ResID is in network byte order.
+	ResID uint16
+}
+
+// SizeOfNFGenMsg is the size of NFGenMsg.
+const SizeOfNFGenMsg = 4
+
+// Connection tracking netlink message types, from
+// uapi/linux/netfilter/nfnetlink_conntrack.h.
+const (
+	IPCTNL_MSG_CT_NEW             = 0
+	IPCTNL_MSG_CT_GET             = 1
+	IPCTNL_MSG_CT_DELETE          = 2
+	IPCTNL_MSG_CT_GET_CTRZERO     = 3
+	IPCTNL_MSG_CT_GET_STATS_CPU   = 4
+	IPCTNL_MSG_CT_GET_STATS       = 5
+	IPCTNL_MSG_CT_GET_DYING       = 6
+	IPCTNL_MSG_CT_GET_UNCONFIRMED = 7
+)
+
+// Connection tracking attributes, from
+// uapi/linux/netfilter/nfnetlink_conntrack.h.
+const (
+	CTA_UNSPEC         = 0
+	CTA_TUPLE_ORIG     = 1
+	CTA_TUPLE_REPLY    = 2
+	CTA_STATUS         = 3
+	CTA_PROTOINFO      = 4
+	CTA_HELP           = 5
+	CTA_NAT_SRC        = 6
+	CTA_TIMEOUT        = 7
+	CTA_MARK           = 8
+	CTA_COUNTERS_ORIG  = 9
+	CTA_COUNTERS_REPLY = 10
+	CTA_USE            = 11
+	CTA_ID             = 12
+	CTA_NAT_DST        = 13
+	CTA_TUPLE_MASTER   = 14
+	CTA_SEQ_ADJ_ORIG   = 15
+	CTA_SEQ_ADJ_REPLY  = 16
+	CTA_SECMARK        = 17
+	CTA_ZONE           = 18
+	CTA_SECCTX         = 19
+	CTA_TIMESTAMP      = 20
+	CTA_MARK_MASK      = 21
+	CTA_LABELS         = 22
+	CTA_LABELS_MASK    = 23
+	CTA_SYNPROXY       = 24
+)
+
+// Connection tracking tuple attributes, from
+// uapi/linux/netfilter/nfnetlink_conntrack.h.
+const (
+	CTA_TUPLE_UNSPEC = 0
+	CTA_TUPLE_IP     = 1
+	CTA_TUPLE_PROTO  = 2
+	CTA_TUPLE_ZONE   = 3
+)
+
+// Connection tracking tuple address attributes, from
+// uapi/linux/netfilter/nfnetlink_conntrack.h.
+const (
+	CTA_IP_UNSPEC = 0
+	CTA_IP_V4_SRC = 1
+	CTA_IP_V4_DST = 2
+	CTA_IP_V6_SRC = 3
+	CTA_IP_V6_DST = 4
+)
+
+// Connection tracking tuple protocol attributes, from
+// uapi/linux/netfilter/nfnetlink_conntrack.h.
+const (
+	CTA_PROTO_UNSPEC      = 0
+	CTA_PROTO_NUM         = 1
+	CTA_PROTO_SRC_PORT    = 2
+	CTA_PROTO_DST_PORT    = 3
+	CTA_PROTO_ICMP_ID     = 4
+	CTA_PROTO_ICMP_TYPE   = 5
+	CTA_PROTO_ICMP_CODE   = 6
+	CTA_PROTO_ICMPV6_ID   = 7
+	CTA_PROTO_ICMPV6_TYPE = 8
+	CTA_PROTO_ICMPV6_CODE = 9
+)
+
+// Connection tracking protocol information attributes, from
+// uapi/linux/netfilter/nfnetlink_conntrack.h.
+const (
+	CTA_PROTOINFO_UNSPEC = 0
+	CTA_PROTOINFO_TCP    = 1
+	CTA_PROTOINFO_DCCP   = 2
+	CTA_PROTOINFO_SCTP   = 3
+)
+
+// Connection tracking TCP information attributes, from
+// uapi/linux/netfilter/nfnetlink_conntrack.h.
+const (
+	CTA_PROTOINFO_TCP_UNSPEC          = 0
+	CTA_PROTOINFO_TCP_STATE           = 1
+	CTA_PROTOINFO_TCP_WSCALE_ORIGINAL = 2
+	CTA_PROTOINFO_TCP_WSCALE_REPLY    = 3
+	CTA_PROTOINFO_TCP_FLAGS_ORIGINAL  = 4
+	CTA_PROTOINFO_TCP_FLAGS_REPLY     = 5
+)
+
+// Connection tracking per-CPU statistics attributes, from
+// uapi/linux/netfilter/nfnetlink_conntrack.h.
+const (
+	CTA_STATS_UNSPEC         = 0
+	CTA_STATS_SEARCHED       = 1
+	CTA_STATS_FOUND          = 2
+	CTA_STATS_NEW            = 3
+	CTA_STATS_INVALID        = 4
+	CTA_STATS_IGNORE         = 5
+	CTA_STATS_DELETE         = 6
+	CTA_STATS_DELETE_LIST    = 7
+	CTA_STATS_INSERT         = 8
+	CTA_STATS_INSERT_FAILED  = 9
+	CTA_STATS_DROP           = 10
+	CTA_STATS_EARLY_DROP     = 11
+	CTA_STATS_ERROR          = 12
+	CTA_STATS_SEARCH_RESTART = 13
+)
+
+// Connection tracking global statistics attributes, from
+// uapi/linux/netfilter/nfnetlink_conntrack.h.
+const (
+	CTA_STATS_GLOBAL_UNSPEC      = 0
+	CTA_STATS_GLOBAL_ENTRIES     = 1
+	CTA_STATS_GLOBAL_MAX_ENTRIES = 2
+)
+
+// Connection tracking status bits, from
+// uapi/linux/netfilter/nf_conntrack_common.h.
+const (
+	IPS_EXPECTED      = 1 << 0
+	IPS_SEEN_REPLY    = 1 << 1
+	IPS_ASSURED       = 1 << 2
+	IPS_CONFIRMED     = 1 << 3
+	IPS_SRC_NAT       = 1 << 4
+	IPS_DST_NAT       = 1 << 5
+	IPS_SEQ_ADJUST    = 1 << 6
+	IPS_SRC_NAT_DONE  = 1 << 7
+	IPS_DST_NAT_DONE  = 1 << 8
+	IPS_DYING         = 1 << 9
+	IPS_FIXED_TIMEOUT = 1 << 10
+	IPS_TEMPLATE      = 1 << 11
+	IPS_UNTRACKED     = 1 << 12
+)
+
+// TCP connection tracking states, from
+// uapi/linux/netfilter/nf_conntrack_tcp.h.
+const (
+	TCP_CONNTRACK_NONE        = 0
+	TCP_CONNTRACK_SYN_SENT    = 1
+	TCP_CONNTRACK_SYN_RECV    = 2
+	TCP_CONNTRACK_ESTABLISHED = 3
+	TCP_CONNTRACK_FIN_WAIT    = 4
+	TCP_CONNTRACK_CLOSE_WAIT  = 5
+	TCP_CONNTRACK_LAST_ACK    = 6
+	TCP_CONNTRACK_TIME_WAIT   = 7
+	TCP_CONNTRACK_CLOSE       = 8
+	TCP_CONNTRACK_SYN_SENT2   = 9
+)
@@ -163,6 +163,52 @@ const (
 	IFLA_GSO_MAX_SIZE    = 41
 )
 
+// Interface link info attributes, nested in IFLA_LINKINFO, from
+// uapi/linux/if_link.h.
+const (
+	IFLA_INFO_UNSPEC     = 0
+	IFLA_INFO_KIND       = 1
+	IFLA_INFO_DATA       = 2
+	IFLA_INFO_XSTATS     = 3
+	IFLA_INFO_SLAVE_KIND = 4
+	IFLA_INFO_SLAVE_DATA = 5
+)
+
+// VXLAN link attributes, nested in IFLA_INFO_DATA, from uapi/linux/if_link.h.
+const (
+	IFLA_VXLAN_UNSPEC     = 0
+	IFLA_VXLAN_ID         = 1
+	IFLA_VXLAN_GROUP      = 2
+	IFLA_VXLAN_LINK       = 3
+	IFLA_VXLAN_LOCAL      = 4
+	IFLA_VXLAN_TTL        = 5
+	IFLA_VXLAN_TOS        = 6
+	IFLA_VXLAN_LEARNING   = 7
+	IFLA_VXLAN_AGEING     = 8
+	IFLA_VXLAN_LIMIT      = 9
+	IFLA_VXLAN_PORT_RANGE = 10
+	IFLA_VXLAN_PROXY      = 11
+	IFLA_VXLAN_RSC        = 12
+	IFLA_VXLAN_L2MISS     = 13
+	IFLA_VXLAN_L3MISS     = 14
+	IFLA_VXLAN_PORT       = 15
+	IFLA_VXLAN_GROUP6     = 16
+	IFLA_VXLAN_LOCAL6     = 17
+)
+
+// GENEVE link attributes, nested in IFLA_INFO_DATA, from
+// uapi/linux/if_link.h.
+const (
+	IFLA_GENEVE_UNSPEC           = 0
+	IFLA_GENEVE_ID               = 1
+	IFLA_GENEVE_REMOTE           = 2
+	IFLA_GENEVE_TTL              = 3
+	IFLA_GENEVE_TOS              = 4
+	IFLA_GENEVE_PORT             = 5
+	IFLA_GENEVE_COLLECT_METADATA = 6
+	IFLA_GENEVE_REMOTE6          = 7
+)
+
 // InterfaceAddrMessage is struct ifaddrmsg, from uapi/linux/if_addr.h.
 type InterfaceAddrMessage struct {
 	Family    uint8
@@ -346,3 +392,157 @@ type RtAttr struct {
 
 // SizeOfRtAttr is the size of RtAttr.
 const SizeOfRtAttr = 4
+
+// NeighborMessage is struct ndmsg, from uapi/linux/neighbour.h.
+type NeighborMessage struct {
+	Family  uint8
+	_       uint8
+	_       uint16
+	Ifindex int32
+	State   uint16
+	Flags   uint8
+	Type    uint8
+}
+
+// SizeOfNeighborMessage is the size of NeighborMessage.
+const SizeOfNeighborMessage = 12
+
+// Neighbor attributes, from uapi/linux/neighbour.h.
+const (
+	NDA_UNSPEC       = 0
+	NDA_DST          = 1
+	NDA_LLADDR       = 2
+	NDA_CACHEINFO    = 3
+	NDA_PROBES       = 4
+	NDA_VLAN         = 5
+	NDA_PORT         = 6
+	NDA_VNI          = 7
+	NDA_IFINDEX      = 8
+	NDA_MASTER       = 9
+	NDA_LINK_NETNSID = 10
+	NDA_SRC_VNI      = 11
+	NDA_PROTOCOL     = 12
+)
+
+// Neighbor cache entry states, from uapi/linux/neighbour.h.
+const (
+	NUD_NONE       = 0x00
+	NUD_INCOMPLETE = 0x01
+	NUD_REACHABLE  = 0x02
+	NUD_STALE      = 0x04
+	NUD_DELAY      = 0x08
+	NUD_PROBE      = 0x10
+	NUD_FAILED     = 0x20
+	NUD_NOARP      = 0x40
+	NUD_PERMANENT  = 0x80
+)
+
+// FibRuleHdr is struct fib_rule_hdr, from uapi/linux/fib_rules.h.
+type FibRuleHdr struct {
+	Family uint8
+	DstLen uint8
+	SrcLen uint8
+	TOS    uint8
+	Table  uint8
+	_      uint8
+	_      uint8
+	Action uint8
+	Flags  uint32
+}
+
+// SizeOfFibRuleHdr is the size of FibRuleHdr.
+const SizeOfFibRuleHdr = 12
+
+// Routing rule attributes, from uapi/linux/fib_rules.h.
+const (
+	FRA_UNSPEC             = 0
+	FRA_DST                = 1
+	FRA_SRC                = 2
+	FRA_IIFNAME            = 3
+	FRA_GOTO               = 4
+	FRA_PRIORITY           = 6
+	FRA_FWMARK             = 10
+	FRA_FLOW               = 11
+	FRA_TUN_ID             = 12
+	FRA_SUPPRESS_IFGROUP   = 13
+	FRA_SUPPRESS_PREFIXLEN = 14
+	FRA_TABLE              = 15
+	FRA_FWMASK             = 16
+	FRA_OIFNAME            = 17
+)
+
+// Routing rule flags, from uapi/linux/fib_rules.h.
+const (
+	FIB_RULE_PERMANENT    = 0x1
+	FIB_RULE_INVERT       = 0x2
+	FIB_RULE_UNRESOLVED   = 0x4
+	FIB_RULE_DEV_DETACHED = 0x8
+	FIB_RULE_OIF_DETACHED = 0x10
+)
+
+// Routing rule actions, from uapi/linux/fib_rules.h.
+const (
+	FR_ACT_UNSPEC      = 0
+	FR_ACT_TO_TBL      = 1
+	FR_ACT_GOTO        = 2
+	FR_ACT_NOP         = 3
+	FR_ACT_BLACKHOLE   = 6
+	FR_ACT_UNREACHABLE = 7
+	FR_ACT_PROHIBIT    = 8
+)
+
+// TCMessage is struct tcmsg, from uapi/linux/rtnetlink.h.
+type TCMessage struct {
+	Family  uint8
+	_       uint8
+	_       uint16
+	Ifindex int32
+	Handle  uint32
+	Parent  uint32
+	Info    uint32
+}
+
+// SizeOfTCMessage is the size of TCMessage.
+const SizeOfTCMessage = 20
+
+// Traffic control attributes, from uapi/linux/rtnetlink.h.
+const (
+	TCA_UNSPEC  = 0
+	TCA_KIND    = 1
+	TCA_OPTIONS = 2
+	TCA_STATS   = 3
+	TCA_XSTATS  = 4
+	TCA_RATE    = 5
+	TCA_FCNT    = 6
+	TCA_STATS2  = 7
+	TCA_STAB    = 8
+)
+
+// Traffic control handles, from uapi/linux/pkt_sched.h.
+const (
+	TC_H_UNSPEC  = 0
+	TC_H_ROOT    = 0xFFFFFFFF
+	TC_H_INGRESS = 0xFFFFFFF1
+)
+
+// IfAddrLblMessage is struct ifaddrlblmsg, from uapi/linux/if_addrlabel.h.
+type IfAddrLblMessage struct {
+	Family    uint8
+	PrefixLen uint8
+	Flags     uint8
+	_         uint8
+	Index     uint32
+}
+
+// SizeOfIfAddrLblMessage is the size of IfAddrLblMessage.
+const SizeOfIfAddrLblMessage = 8
+
+// Address label attributes, from uapi/linux/if_addrlabel.h.
+const (
+	IFAL_ADDRESS = 1
+	IFAL_LABEL   = 2
+)
+
+// IPV6_ADDR_LABEL_DEFAULT is the label of addresses that do not match any
+// entry of the address label table, from net/ipv6/addrlabel.c.
+const IPV6_ADDR_LABEL_DEFAULT = 0xffffffff
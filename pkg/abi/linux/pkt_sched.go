@@ -0,0 +1,127 @@
+// Copyright 2021 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package linux
+
+// PSCHED_SHIFT is the base 2 logarithm of the number of nanoseconds in a
+// packet scheduler tick, from net/pkt_sched.h. It matches the clock resolution
+// reported in /proc/net/psched.
+const PSCHED_SHIFT = 6
+
+// TCRateSpec is struct tc_ratespec, from uapi/linux/pkt_sched.h.
+type TCRateSpec struct {
+	CellLog   uint8
+	LinkLayer uint8
+	Overhead  uint16
+	CellAlign int16
+	MPU       uint16
+	Rate      uint32
+}
+
+// Link layers of TCRateSpec, from uapi/linux/pkt_sched.h.
+const (
+	TC_LINKLAYER_UNAWARE  = 0
+	TC_LINKLAYER_ETHERNET = 1
+	TC_LINKLAYER_ATM      = 2
+)
+
+// TCTBFQopt is struct tc_tbf_qopt, from uapi/linux/pkt_sched.h.
+type TCTBFQopt struct {
+	Rate     TCRateSpec
+	PeakRate TCRateSpec
+	Limit    uint32
+	Buffer   uint32
+	MTU      uint32
+}
+
+// SizeOfTCTBFQopt is the size of TCTBFQopt.
+const SizeOfTCTBFQopt = 36
+
+// TBF attributes, from uapi/linux/pkt_sched.h.
+const (
+	TCA_TBF_UNSPEC  = 0
+	TCA_TBF_PARMS   = 1
+	TCA_TBF_RTAB    = 2
+	TCA_TBF_PTAB    = 3
+	TCA_TBF_RATE64  = 4
+	TCA_TBF_PRATE64 = 5
+	TCA_TBF_BURST   = 6
+	TCA_TBF_PBURST  = 7
+	TCA_TBF_PAD     = 8
+)
+
+// TCNetemQopt is struct tc_netem_qopt, from uapi/linux/pkt_sched.h.
+type TCNetemQopt struct {
+	Latency   uint32
+	Limit     uint32
+	Loss      uint32
+	Gap       uint32
+	Duplicate uint32
+	Jitter    uint32
+}
+
+// SizeOfTCNetemQopt is the size of TCNetemQopt.
+const SizeOfTCNetemQopt = 24
+
+// Netem attributes, from uapi/linux/pkt_sched.h.
+const (
+	TCA_NETEM_UNSPEC     = 0
+	TCA_NETEM_CORR       = 1
+	TCA_NETEM_DELAY_DIST = 2
+	TCA_NETEM_REORDER    = 3
+	TCA_NETEM_CORRUPT    = 4
+	TCA_NETEM_LOSS       = 5
+	TCA_NETEM_RATE       = 6
+	TCA_NETEM_ECN        = 7
+	TCA_NETEM_RATE64     = 8
+	TCA_NETEM_PAD        = 9
+	TCA_NETEM_LATENCY64  = 10
+	TCA_NETEM_JITTER64   = 11
+	TCA_NETEM_SLOT       = 12
+	TCA_NETEM_SLOT_DIST  = 13
+)
+
+// FQ attributes, from uapi/linux/pkt_sched.h.
+const (
+	TCA_FQ_UNSPEC             = 0
+	TCA_FQ_PLIMIT             = 1
+	TCA_FQ_FLOW_PLIMIT        = 2
+	TCA_FQ_QUANTUM            = 3
+	TCA_FQ_INITIAL_QUANTUM    = 4
+	TCA_FQ_RATE_ENABLE        = 5
+	TCA_FQ_FLOW_DEFAULT_RATE  = 6
+	TCA_FQ_FLOW_MAX_RATE      = 7
+	TCA_FQ_BUCKETS_LOG        = 8
+	TCA_FQ_FLOW_REFILL_DELAY  = 9
+	TCA_FQ_ORPHAN_MASK        = 10
+	TCA_FQ_LOW_RATE_THRESHOLD = 11
+	TCA_FQ_CE_THRESHOLD       = 12
+	TCA_FQ_TIMER_SLACK        = 13
+	TCA_FQ_HORIZON            = 14
+	TCA_FQ_HORIZON_DROP       = 15
+)
+
+// FQ_CODEL attributes, from uapi/linux/pkt_sched.h.
+const (
+	TCA_FQ_CODEL_UNSPEC          = 0
+	TCA_FQ_CODEL_TARGET          = 1
+	TCA_FQ_CODEL_LIMIT           = 2
+	TCA_FQ_CODEL_INTERVAL        = 3
+	TCA_FQ_CODEL_ECN             = 4
+	TCA_FQ_CODEL_FLOWS           = 5
+	TCA_FQ_CODEL_QUANTUM         = 6
+	TCA_FQ_CODEL_CE_THRESHOLD    = 7
+	TCA_FQ_CODEL_DROP_BATCH_SIZE = 8
+	TCA_FQ_CODEL_MEMORY_LIMIT    = 9
+)
@@ -0,0 +1,80 @@
+// Copyright 2021 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package linux
+
+// ioctl(2) request numbers from uapi/linux/sev-guest.h, for /dev/sev-guest.
+var (
+	SNP_GET_REPORT      = IOC(_IOC_READ|_IOC_WRITE, 'S', 0x0, SizeOfSNPGuestRequestIoctl)
+	SNP_GET_DERIVED_KEY = IOC(_IOC_READ|_IOC_WRITE, 'S', 0x1, SizeOfSNPGuestRequestIoctl)
+	SNP_GET_EXT_REPORT  = IOC(_IOC_READ|_IOC_WRITE, 'S', 0x2, SizeOfSNPGuestRequestIoctl)
+)
+
+// SNPGuestRequestIoctl is struct snp_guest_request_ioctl, from
+// uapi/linux/sev-guest.h. ReqData and RespData point to a request and a
+// response whose types depend on the ioctl.
+//
+// +marshal
+type SNPGuestRequestIoctl struct {
+	MsgVersion uint8
+	_          [7]byte
+	ReqData    uint64
+	RespData   uint64
+	// ExitInfo2 holds the firmware error in its lower 32 bits and the VMM
+	// error in its upper 32 bits.
+	ExitInfo2 uint64
+}
+
+// SizeOfSNPGuestRequestIoctl is the size of struct snp_guest_request_ioctl.
+const SizeOfSNPGuestRequestIoctl = 32
+
+// SNPReportReq is struct snp_report_req, the request of SNP_GET_REPORT.
+//
+// +marshal
+type SNPReportReq struct {
+	UserData [64]byte
+	VMPL     uint32
+	_        [28]byte
+}
+
+// SNPExtReportReq is struct snp_ext_report_req, the request of
+// SNP_GET_EXT_REPORT.
+//
+// +marshal
+type SNPExtReportReq struct {
+	Data         SNPReportReq
+	CertsAddress uint64
+	CertsLen     uint32
+	_            uint32
+}
+
+// Sizes of SEV-SNP guest request and response messages, from
+// uapi/linux/sev-guest.h.
+const (
+	// SizeOfSNPReportResp is the size of struct snp_report_resp, the
+	// response of SNP_GET_REPORT and SNP_GET_EXT_REPORT.
+	SizeOfSNPReportResp = 4000
+
+	// SizeOfSNPDerivedKeyReq is the size of struct snp_derived_key_req, the
+	// request of SNP_GET_DERIVED_KEY.
+	SizeOfSNPDerivedKeyReq = 32
+
+	// SizeOfSNPDerivedKeyResp is the size of struct snp_derived_key_resp,
+	// the response of SNP_GET_DERIVED_KEY.
+	SizeOfSNPDerivedKeyResp = 64
+)
+
+// SEV_FW_BLOB_MAX_SIZE is the maximum size of the certificate blob returned by
+// SNP_GET_EXT_REPORT, from drivers/virt/coco/sev-guest/sev-guest.c.
+const SEV_FW_BLOB_MAX_SIZE = 0x4000
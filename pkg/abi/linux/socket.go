@@ -102,6 +102,7 @@ const (
 	SOL_RAW     = 255
 	SOL_PACKET  = 263
 	SOL_NETLINK = 270
+	SOL_TLS     = 282
 )
 
 // A SockType is a type (as opposed to family) of sockets. These are enumerated
@@ -204,6 +205,7 @@ const (
 	SO_PEERGROUPS            = 59
 	SO_ZEROCOPY              = 60
 	SO_TXTIME                = 61
+	SO_BINDTOIFINDEX         = 62
 )
 
 // enum socket_state, from uapi/linux/net.h.
@@ -268,6 +270,28 @@ type InetMulticastRequestWithNIC struct {
 	InterfaceIndex int32
 }
 
+// InetMulticastSourceRequest is struct ip_mreq_source, from uapi/linux/in.h.
+type InetMulticastSourceRequest struct {
+	MulticastAddr InetAddr
+	InterfaceAddr InetAddr
+	SourceAddr    InetAddr
+}
+
+// GroupRequest is struct group_req, from uapi/linux/in.h.
+type GroupRequest struct {
+	InterfaceIndex uint32
+	_              uint32
+	Group          [SockAddrMax]byte
+}
+
+// GroupSourceRequest is struct group_source_req, from uapi/linux/in.h.
+type GroupSourceRequest struct {
+	InterfaceIndex uint32
+	_              uint32
+	Group          [SockAddrMax]byte
+	Source         [SockAddrMax]byte
+}
+
 // Inet6Addr is struct in6_addr, from uapi/linux/in6.h.
 //
 // +marshal
@@ -423,8 +447,11 @@ var SizeOfTCPInfo = int(binary.Size(TCPInfo{}))
 
 // Control message types, from linux/socket.h.
 const (
-	SCM_CREDENTIALS = 0x2
-	SCM_RIGHTS      = 0x1
+	SCM_CREDENTIALS  = 0x2
+	SCM_RIGHTS       = 0x1
+	SCM_TIMESTAMP    = SO_TIMESTAMP
+	SCM_TIMESTAMPNS  = SO_TIMESTAMPNS
+	SCM_TIMESTAMPING = SO_TIMESTAMPING
 )
 
 // A ControlMessageHeader is the header for a socket control message.
@@ -462,6 +489,16 @@ type ControlMessageIPPacketInfo struct {
 	DestinationAddr InetAddr
 }
 
+// A ControlMessageIPv6PacketInfo is IPV6_PKTINFO socket control message.
+//
+// ControlMessageIPv6PacketInfo represents struct in6_pktinfo from linux/ipv6.h.
+//
+// +stateify savable
+type ControlMessageIPv6PacketInfo struct {
+	Addr Inet6Addr
+	NIC  uint32
+}
+
 // SizeOfControlMessageCredentials is the binary size of a
 // ControlMessageCredentials struct.
 var SizeOfControlMessageCredentials = int(binary.Size(ControlMessageCredentials{}))
@@ -476,6 +513,10 @@ const SizeOfControlMessageRight = 4
 // SizeOfControlMessageInq is the size of a TCP_INQ control message.
 const SizeOfControlMessageInq = 4
 
+// SizeOfControlMessageTimespec is the size of an SCM_TIMESTAMPNS control
+// message.
+const SizeOfControlMessageTimespec = 16
+
 // SizeOfControlMessageTOS is the size of an IP_TOS control message.
 const SizeOfControlMessageTOS = 1
 
@@ -486,6 +527,21 @@ const SizeOfControlMessageTClass = 4
 // control message.
 const SizeOfControlMessageIPPacketInfo = 12
 
+// SizeOfControlMessageIPv6PacketInfo is the size of an IPV6_PKTINFO
+// control message.
+const SizeOfControlMessageIPv6PacketInfo = 20
+
+// SizeOfControlMessageTTL is the size of an IP_TTL control message.
+const SizeOfControlMessageTTL = 4
+
+// SizeOfControlMessageHopLimit is the size of an IPV6_HOPLIMIT control
+// message.
+const SizeOfControlMessageHopLimit = 4
+
+// SizeOfControlMessageFlowInfo is the size of an IPV6_FLOWINFO control
+// message.
+const SizeOfControlMessageFlowInfo = 4
+
 // SCM_MAX_FD is the maximum number of FDs accepted in a single sendmsg call.
 // From net/scm.h.
 const SCM_MAX_FD = 253
@@ -53,6 +53,30 @@ const (
 	TCP_INQ                  = 36
 )
 
+// Flags for struct tcp_md5sig, from uapi/linux/tcp.h.
+const (
+	TCP_MD5SIG_FLAG_PREFIX  = 0x1
+	TCP_MD5SIG_FLAG_IFINDEX = 0x2
+)
+
+// TCP_MD5SIG_MAXKEYLEN is the maximum length of a TCP MD5 signature key, from
+// uapi/linux/tcp.h.
+const TCP_MD5SIG_MAXKEYLEN = 80
+
+// TCPMD5Sig is struct tcp_md5sig, from uapi/linux/tcp.h. It is the argument of
+// the TCP_MD5SIG and TCP_MD5SIG_EXT socket options.
+type TCPMD5Sig struct {
+	Addr      [SockAddrMax]byte
+	Flags     uint8
+	PrefixLen uint8
+	KeyLen    uint16
+	IfIndex   int32
+	Key       [TCP_MD5SIG_MAXKEYLEN]byte
+}
+
+// SizeOfTCPMD5Sig is the size of a TCPMD5Sig struct.
+const SizeOfTCPMD5Sig = 216
+
 // Socket constants from include/net/tcp.h.
 const (
 	MAX_TCP_KEEPIDLE  = 32767
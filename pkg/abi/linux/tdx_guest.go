@@ -0,0 +1,37 @@
+// Copyright 2021 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package linux
+
+// Sizes of struct tdx_report_req fields, from uapi/linux/tdx-guest.h.
+const (
+	TDX_REPORTDATA_LEN = 64
+	TDX_REPORT_LEN     = 1024
+)
+
+// TDXReportReq is struct tdx_report_req, from uapi/linux/tdx-guest.h.
+//
+// +marshal
+type TDXReportReq struct {
+	ReportData [TDX_REPORTDATA_LEN]byte
+	TDReport   [TDX_REPORT_LEN]byte
+}
+
+// SizeOfTDXReportReq is the size of struct tdx_report_req.
+const SizeOfTDXReportReq = TDX_REPORTDATA_LEN + TDX_REPORT_LEN
+
+// ioctl(2) request numbers from uapi/linux/tdx-guest.h, for /dev/tdx_guest.
+var (
+	TDX_CMD_GET_REPORT0 = IOC(_IOC_READ|_IOC_WRITE, 'T', 1, SizeOfTDXReportReq)
+)
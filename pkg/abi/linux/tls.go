@@ -0,0 +1,67 @@
+// Copyright 2021 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package linux
+
+// Socket options from uapi/linux/tls.h.
+const (
+	TLS_TX = 1
+	TLS_RX = 2
+)
+
+// Control message types from uapi/linux/tls.h.
+const (
+	TLS_SET_RECORD_TYPE = 1
+	TLS_GET_RECORD_TYPE = 2
+)
+
+// Supported TLS versions, from uapi/linux/tls.h.
+const (
+	TLS_1_2_VERSION = 0x0303
+	TLS_1_3_VERSION = 0x0304
+)
+
+// Supported ciphers, from uapi/linux/tls.h.
+const (
+	TLS_CIPHER_AES_GCM_128       = 51
+	TLS_CIPHER_AES_GCM_256       = 52
+	TLS_CIPHER_CHACHA20_POLY1305 = 54
+)
+
+// Sizes of the parameters of the supported ciphers, from uapi/linux/tls.h.
+const (
+	TLS_CIPHER_AES_GCM_128_IV_SIZE      = 8
+	TLS_CIPHER_AES_GCM_128_KEY_SIZE     = 16
+	TLS_CIPHER_AES_GCM_128_SALT_SIZE    = 4
+	TLS_CIPHER_AES_GCM_128_REC_SEQ_SIZE = 8
+
+	TLS_CIPHER_AES_GCM_256_IV_SIZE      = 8
+	TLS_CIPHER_AES_GCM_256_KEY_SIZE     = 32
+	TLS_CIPHER_AES_GCM_256_SALT_SIZE    = 4
+	TLS_CIPHER_AES_GCM_256_REC_SEQ_SIZE = 8
+
+	TLS_CIPHER_CHACHA20_POLY1305_IV_SIZE      = 12
+	TLS_CIPHER_CHACHA20_POLY1305_KEY_SIZE     = 32
+	TLS_CIPHER_CHACHA20_POLY1305_SALT_SIZE    = 0
+	TLS_CIPHER_CHACHA20_POLY1305_REC_SEQ_SIZE = 8
+)
+
+// SizeOfTLSCryptoInfo is the size of struct tls_crypto_info, from
+// uapi/linux/tls.h: the version and cipher type that start the TLS_TX and
+// TLS_RX socket options, followed by the parameters of the cipher.
+const SizeOfTLSCryptoInfo = 4
+
+// SizeOfControlMessageTLSRecordType is the size of a TLS_SET_RECORD_TYPE or
+// TLS_GET_RECORD_TYPE control message.
+const SizeOfControlMessageTLSRecordType = 1
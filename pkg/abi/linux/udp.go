@@ -0,0 +1,36 @@
+// Copyright 2021 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package linux
+
+// Socket options from uapi/linux/udp.h.
+const (
+	UDP_CORK         = 1
+	UDP_ENCAP        = 100
+	UDP_NO_CHECK6_TX = 101
+	UDP_NO_CHECK6_RX = 102
+	UDP_SEGMENT      = 103
+	UDP_GRO          = 104
+)
+
+// UDP_MAX_SEGMENTS is the maximum number of datagrams a single UDP_SEGMENT
+// write may be split into, from include/linux/udp.h.
+const UDP_MAX_SEGMENTS = 1 << 6
+
+// SizeOfControlMessageUDPSegment is the size of a UDP_SEGMENT control
+// message.
+const SizeOfControlMessageUDPSegment = 2
+
+// SizeOfControlMessageUDPGRO is the size of a UDP_GRO control message.
+const SizeOfControlMessageUDPGRO = 4
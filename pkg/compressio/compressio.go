@@ -49,6 +49,7 @@ import (
 	"crypto/hmac"
 	"crypto/sha256"
 	"errors"
+	"fmt"
 	"hash"
 	"io"
 	"runtime"
@@ -585,6 +586,16 @@ var _ io.Writer = (*Writer)(nil)
 // buffered (in the form of read-ahead, or buffered writes), and is limited to
 // O(chunkSize * [1+GOMAXPROCS]).
 func NewWriter(out io.Writer, key []byte, chunkSize uint32, level int) (*Writer, error) {
+	return NewWriterWorkers(out, key, chunkSize, level, 1+runtime.GOMAXPROCS(0))
+}
+
+// NewWriterWorkers is equivalent to NewWriter, but compresses chunks in the
+// given number of goroutines, which must be at least 1. Extra memory is limited
+// to O(chunkSize * [1+workers]).
+func NewWriterWorkers(out io.Writer, key []byte, chunkSize uint32, level int, workers int) (*Writer, error) {
+	if workers < 1 {
+		return nil, fmt.Errorf("invalid number of workers %d", workers)
+	}
 	w := &Writer{
 		pool: pool{
 			chunkSize: chunkSize,
@@ -592,7 +603,7 @@ func NewWriter(out io.Writer, key []byte, chunkSize uint32, level int) (*Writer,
 		},
 		out: out,
 	}
-	w.init(key, 1+runtime.GOMAXPROCS(0), true, level)
+	w.init(key, workers, true, level)
 
 	if err := binary.WriteUint32(w.out, binary.BigEndian, chunkSize); err != nil {
 		return nil, err
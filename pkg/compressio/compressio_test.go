@@ -206,6 +206,27 @@ func TestCompress(t *testing.T) {
 	}
 }
 
+func TestCompressWorkers(t *testing.T) {
+	data := initTest(t, 1024*1024)
+	for _, level := range []int{flate.NoCompression, flate.BestSpeed, flate.BestCompression} {
+		for _, workers := range []int{1, 4} {
+			doTest(t, testOpts{
+				Name: fmt.Sprintf("level=%d, workers=%d", level, workers),
+				Data: data,
+				NewWriter: func(b *bytes.Buffer) (io.Writer, error) {
+					return NewWriterWorkers(b, hashKey, 16*1024, level, workers)
+				},
+				NewReader: func(b *bytes.Buffer) (io.Reader, error) {
+					return NewReader(b, hashKey)
+				},
+			})
+		}
+	}
+	if _, err := NewWriterWorkers(new(bytes.Buffer), nil, 1024, flate.BestSpeed, 0); err == nil {
+		t.Errorf("NewWriterWorkers with no workers succeeded, expected error")
+	}
+}
+
 const (
 	benchDataSize = 600 * 1024 * 1024
 )
@@ -27,6 +27,12 @@
 //   }
 package cpuid
 
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
 // Feature is a unique identifier for a particular cpu feature. We just use an
 // int as a feature number on x86 and arm64.
 //
@@ -47,3 +53,37 @@ type ErrIncompatible struct {
 func (e ErrIncompatible) Error() string {
 	return e.message
 }
+
+// Restrict removes all features from fs other than those named in allowed,
+// using the names from /proc/cpuinfo. This limits the features visible to a
+// sandbox to those common to every host that it may be restored on.
+func (fs *FeatureSet) Restrict(allowed []string) error {
+	keep := make(map[Feature]bool, len(allowed))
+	for _, name := range allowed {
+		f, ok := FeatureFromString(name)
+		if !ok {
+			return fmt.Errorf("unknown CPU feature %q", name)
+		}
+		keep[f] = true
+	}
+	for f := range fs.Set {
+		if !keep[f] {
+			fs.Remove(f)
+		}
+	}
+	return nil
+}
+
+// featureList returns the names of the given features, in a stable order.
+func featureList(features map[Feature]bool) string {
+	fs := make([]Feature, 0, len(features))
+	for f := range features {
+		fs = append(fs, f)
+	}
+	sort.Slice(fs, func(i, j int) bool { return fs[i] < fs[j] })
+	names := make([]string, 0, len(fs))
+	for _, f := range fs {
+		names = append(names, f.String())
+	}
+	return strings.Join(names, " ")
+}
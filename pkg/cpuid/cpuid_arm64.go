@@ -23,6 +23,7 @@ import (
 	"io/ioutil"
 	"strconv"
 	"strings"
+	"syscall"
 
 	"gvisor.dev/gvisor/pkg/log"
 )
@@ -138,8 +139,22 @@ const (
 	_AT_HWCAP2 = 26 // hardware capability bit vector 2
 )
 
+// Values used by prctl(2) to query the SVE vector length.
+//
+// Ref arch/arm64/include/uapi/linux/prctl.h
+const (
+	_PR_SVE_GET_VL      = 51
+	_PR_SVE_VL_LEN_MASK = 0xffff
+)
+
 // These should not be changed after they are initialized.
-var hwCap uint
+var (
+	hwCap uint
+
+	// sveVL is the host's SVE vector length in bytes, or 0 if SVE is
+	// unavailable.
+	sveVL uint
+)
 
 // To make emulation of /proc/cpuinfo easy, these names match the names of the
 // basic features in Linux defined in arch/arm64/kernel/cpuinfo.c.
@@ -227,11 +242,20 @@ type FeatureSet struct {
 
 	// CPURevision is part of the processor signature.
 	CPURevision uint8
+
+	// SVEVectorLength is the length of SVE vector registers in bytes. It is
+	// 0 if SVE is not in Set.
+	SVEVectorLength uint
 }
 
 // CheckHostCompatible returns nil if fs is a subset of the host feature set.
-// Noop on arm64.
+//
+// Only the SVE vector length is checked on arm64, since SVE register state
+// saved with one vector length can't be restored with another.
 func (fs *FeatureSet) CheckHostCompatible() error {
+	if fs.HasFeature(ARM64FeatureSVE) && fs.SVEVectorLength != sveVL {
+		return fmt.Errorf("SVE vector length %d is not supported by the host (vector length %d)", fs.SVEVectorLength, sveVL)
+	}
 	return nil
 }
 
@@ -249,7 +273,47 @@ func (fs *FeatureSet) ExtendedStateSize() (size, align uint) {
 	//	  __u32           fpcr;
 	//	  __u32           __reserved[2];
 	// };
-	return 528, 16
+	//
+	// If SVE is supported, extended state is instead saved in the format
+	// of the NT_ARM_SVE regset, which can hold either the above or the
+	// SVE registers; see SVEStateSize.
+	if fs.UseSVE() {
+		return SVEStateSize(fs.SVEVectorLength), 16
+	}
+	return fpsimdStateSize, 16
+}
+
+// fpsimdStateSize is the size of struct user_fpsimd_state.
+const fpsimdStateSize = 528
+
+// SVEHeaderSize is the size of struct user_sve_header, which precedes
+// register state in the NT_ARM_SVE regset.
+const SVEHeaderSize = 16
+
+// SVEStateSize returns the size of the NT_ARM_SVE regset, including its
+// header, for the given vector length in bytes, when it holds SVE registers.
+//
+// Ref arch/arm64/include/uapi/asm/ptrace.h, SVE_PT_SVE_SIZE.
+func SVEStateSize(vl uint) uint {
+	// 32 Z registers of vl bytes, followed by 16 P registers and FFR of
+	// vl/8 bytes each, followed by FPSR and FPCR (4 bytes each), with each
+	// region aligned to 16 bytes.
+	regs := 32*vl + 17*(vl/8)
+	fpsrOff := roundUp16(SVEHeaderSize + regs)
+	return roundUp16(fpsrOff + 8)
+}
+
+func roundUp16(n uint) uint {
+	return (n + 15) &^ 15
+}
+
+// Remove removes a Feature from a FeatureSet. It ignores features that are
+// not in the FeatureSet.
+func (fs *FeatureSet) Remove(feature Feature) {
+	delete(fs.Set, feature)
+	if feature == ARM64FeatureSVE {
+		fs.SVEVectorLength = 0
+	}
 }
 
 // HasFeature tests whether or not a feature is in the given feature set.
@@ -264,6 +328,12 @@ func (fs *FeatureSet) UseXsave() bool {
 	return false
 }
 
+// UseSVE returns true if extended state for 'fs' includes SVE registers, and
+// is thus saved in the format of the NT_ARM_SVE regset.
+func (fs *FeatureSet) UseSVE() bool {
+	return fs.HasFeature(ARM64FeatureSVE) && fs.SVEVectorLength != 0
+}
+
 // FlagsString prints out supported CPU "flags" field in /proc/cpuinfo.
 func (fs *FeatureSet) FlagsString() string {
 	var s []string
@@ -301,6 +371,10 @@ func HostFeatureSet() *FeatureSet {
 			s[f] = true
 		}
 	}
+	if sveVL == 0 {
+		// Without a vector length, SVE state can't be saved.
+		delete(s, ARM64FeatureSVE)
+	}
 
 	return &FeatureSet{
 		Set:             s,
@@ -309,6 +383,7 @@ func HostFeatureSet() *FeatureSet {
 		CPUVariant:      uint8(cpuVarHex),
 		CPUPartnum:      uint16(cpuPartHex),
 		CPURevision:     uint8(cpuRevDec),
+		SVEVectorLength: sveVL,
 	}
 }
 
@@ -470,6 +545,20 @@ func initHwCap() {
 	}
 }
 
+// initSVE reads the host's SVE vector length, which is the vector length that
+// application threads will run with.
+func initSVE() {
+	if hwCap&(1<<ARM64FeatureSVE) == 0 {
+		return
+	}
+	ret, _, errno := syscall.RawSyscall(syscall.SYS_PRCTL, _PR_SVE_GET_VL, 0, 0)
+	if errno != 0 {
+		log.Warningf("Could not get SVE vector length: %v", errno)
+		return
+	}
+	sveVL = uint(ret & _PR_SVE_VL_LEN_MASK)
+}
+
 func initFeaturesFromString() {
 	for f, s := range arm64FeatureStrings {
 		arm64FeaturesFromString[s] = f
@@ -479,5 +568,6 @@ func initFeaturesFromString() {
 func init() {
 	initCPUInfo()
 	initHwCap()
+	initSVE()
 	initFeaturesFromString()
 }
@@ -53,3 +53,38 @@ func TestFeatureFromString(t *testing.T) {
 		t.Errorf("got %v want nothing", f)
 	}
 }
+
+func TestSVEStateSize(t *testing.T) {
+	for _, test := range []struct {
+		vl   uint
+		want uint
+	}{
+		{vl: 16, want: 592},
+		{vl: 32, want: 1136},
+		{vl: 256, want: 8768},
+	} {
+		if got := SVEStateSize(test.vl); got != test.want {
+			t.Errorf("SVEStateSize(%d) = %d, want %d", test.vl, got, test.want)
+		}
+	}
+}
+
+func TestRemoveSVE(t *testing.T) {
+	fs := &FeatureSet{
+		Set: map[Feature]bool{
+			ARM64FeatureFP:  true,
+			ARM64FeatureSVE: true,
+		},
+		SVEVectorLength: 32,
+	}
+	if !fs.UseSVE() {
+		t.Fatalf("UseSVE() = false with SVE in %v", fs)
+	}
+	fs.Remove(ARM64FeatureSVE)
+	if fs.UseSVE() {
+		t.Errorf("UseSVE() = true after removing SVE from %v", fs)
+	}
+	if size, _ := fs.ExtendedStateSize(); size != fpsimdStateSize {
+		t.Errorf("ExtendedStateSize() = %d, want %d", size, fpsimdStateSize)
+	}
+}
@@ -681,12 +681,20 @@ func (fs *FeatureSet) Intel() bool {
 	return fs.VendorID == intelVendorID
 }
 
+// description returns the vendor and signature of the CPU described by fs.
+func (fs *FeatureSet) description() string {
+	return fmt.Sprintf("%s family %d model %d", fs.VendorID, ((fs.ExtendedFamily<<4)&0xff)|fs.Family, ((fs.ExtendedModel<<4)&0xff)|fs.Model)
+}
+
 // CheckHostCompatible returns nil if fs is a subset of the host feature set.
+//
+// The vendor and signature of the host may differ from those of fs; only the
+// features that fs exposes are required.
 func (fs *FeatureSet) CheckHostCompatible() error {
 	hfs := HostFeatureSet()
 
 	if diff := fs.Subtract(hfs); diff != nil {
-		return ErrIncompatible{fmt.Sprintf("CPU feature set %v incompatible with host feature set %v (missing: %v)", fs.FlagsString(false), hfs.FlagsString(false), diff)}
+		return ErrIncompatible{fmt.Sprintf("CPU features [%s] are not supported by the host (feature set from %s, host is %s)", featureList(diff), fs.description(), hfs.description())}
 	}
 
 	// The size of a cache line must match, as it is critical to correctly
@@ -127,6 +127,23 @@ func TestRemove(t *testing.T) {
 	}
 }
 
+func TestRestrict(t *testing.T) {
+	testFeatures := newEmptyFeatureSet()
+	testFeatures.Add(X86FeatureFPU)
+	testFeatures.Add(X86FeaturePAE)
+	testFeatures.Add(X86FeatureAVX)
+	if err := testFeatures.Restrict([]string{"fpu", "avx", "sse4a"}); err != nil {
+		t.Fatalf("Restrict failed: %v", err)
+	}
+	if !testFeatures.HasFeature(X86FeatureFPU) || !testFeatures.HasFeature(X86FeatureAVX) || len(testFeatures.Set) != 2 {
+		t.Errorf("Restrict failed, got %v want fpu and avx", testFeatures.FlagsString(false))
+	}
+
+	if err := testFeatures.Restrict([]string{"bad"}); err == nil {
+		t.Errorf("Restrict with unknown feature succeeded, want error")
+	}
+}
+
 func TestFeatureFromString(t *testing.T) {
 	f, ok := FeatureFromString("avx")
 	if f != X86FeatureAVX || !ok {
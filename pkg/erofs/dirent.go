@@ -0,0 +1,118 @@
+// Copyright 2021 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package erofs
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"syscall"
+)
+
+// direntSize is the size of struct erofs_dirent.
+const direntSize = 12
+
+// File types of directory entries.
+const (
+	FileTypeUnknown   = 0
+	FileTypeRegular   = 1
+	FileTypeDirectory = 2
+	FileTypeCharDev   = 3
+	FileTypeBlockDev  = 4
+	FileTypeFIFO      = 5
+	FileTypeSocket    = 6
+	FileTypeSymlink   = 7
+)
+
+// Dirent is a directory entry.
+type Dirent struct {
+	Name     string
+	Nid      uint64
+	FileType uint8
+}
+
+// Dirents returns the entries of a directory, including "." and "..", in the
+// order in which they are stored, which is sorted by name.
+func (in *Inode) Dirents() ([]Dirent, error) {
+	if !in.IsDir() {
+		return nil, syscall.ENOTDIR
+	}
+	bs := uint64(in.image.BlockSize())
+	buf := make([]byte, bs)
+	var dirents []Dirent
+	for off := uint64(0); off < in.Size; off += bs {
+		size := in.Size - off
+		if size > bs {
+			size = bs
+		}
+		block := buf[:size]
+		if n, err := in.ReadAt(block, int64(off)); uint64(n) < size {
+			if err == nil || err == io.EOF {
+				err = errCorrupted
+			}
+			return nil, err
+		}
+		ds, err := parseDirentBlock(block)
+		if err != nil {
+			return nil, err
+		}
+		dirents = append(dirents, ds...)
+	}
+	return dirents, nil
+}
+
+// parseDirentBlock parses a directory block. The block starts with an array of
+// struct erofs_dirent, followed by the names of the entries, which aren't NUL
+// terminated except possibly the last one.
+func parseDirentBlock(block []byte) ([]Dirent, error) {
+	if len(block) < direntSize {
+		return nil, errCorrupted
+	}
+	le := binary.LittleEndian
+	// The names start right after the array, so the first entry's name
+	// offset gives the number of entries.
+	namesOff := int(le.Uint16(block[8:]))
+	if namesOff < direntSize || namesOff%direntSize != 0 || namesOff > len(block) {
+		return nil, errCorrupted
+	}
+	count := namesOff / direntSize
+	dirents := make([]Dirent, 0, count)
+	for j := 0; j < count; j++ {
+		d := block[j*direntSize:]
+		nameOff := int(le.Uint16(d[8:]))
+		nameEnd := len(block)
+		if j+1 < count {
+			nameEnd = int(le.Uint16(d[direntSize+8:]))
+		}
+		if nameOff < namesOff || nameEnd < nameOff || nameEnd > len(block) {
+			return nil, errCorrupted
+		}
+		name := block[nameOff:nameEnd]
+		if j+1 == count {
+			if k := bytes.IndexByte(name, 0); k >= 0 {
+				name = name[:k]
+			}
+		}
+		if len(name) == 0 || len(name) > MaxFileName {
+			return nil, errCorrupted
+		}
+		dirents = append(dirents, Dirent{
+			Name:     string(name),
+			Nid:      le.Uint64(d[0:]),
+			FileType: d[10],
+		})
+	}
+	return dirents, nil
+}
@@ -0,0 +1,197 @@
+// Copyright 2021 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package erofs reads EROFS filesystem images.
+//
+// Uncompressed images are supported, including chunk-based files whose chunks
+// all live in the primary device. Compressed files can be looked up and
+// stat'ed, but reading their data fails with EOPNOTSUPP.
+//
+// See Linux's fs/erofs/erofs_fs.h for the on-disk format.
+package erofs
+
+import (
+	"encoding/binary"
+	"io"
+	"syscall"
+)
+
+// Superblock location and magic.
+const (
+	// SuperBlockOffset is the offset of the superblock in the image.
+	SuperBlockOffset = 1024
+
+	// SuperBlockMagicV1 is the magic number of EROFS images.
+	SuperBlockMagicV1 = 0xe0f5e1e2
+
+	// superBlockSize is the size of the on-disk superblock, not including
+	// extension slots.
+	superBlockSize = 128
+)
+
+// Block size limits, as log2 of the block size.
+const (
+	minBlockSizeBits = 9
+	maxBlockSizeBits = 16
+)
+
+// MaxFileName is the maximum length of a file name.
+const MaxFileName = 255
+
+// Incompatible features.
+const (
+	featureIncompatZeroPadding  = 0x1
+	featureIncompatComprCfgs    = 0x2
+	featureIncompatChunkedFile  = 0x4
+	featureIncompatDeviceTable  = 0x8
+	featureIncompatZtailPacking = 0x10
+	featureIncompatFragments    = 0x20
+	featureIncompatXattrPrefix  = 0x40
+
+	// featureIncompatSupported is the set of incompatible features that
+	// don't prevent images from being read. Compression features only affect
+	// compressed files, which are rejected when they are read.
+	featureIncompatSupported = featureIncompatZeroPadding |
+		featureIncompatComprCfgs |
+		featureIncompatChunkedFile |
+		featureIncompatDeviceTable |
+		featureIncompatZtailPacking |
+		featureIncompatFragments |
+		featureIncompatXattrPrefix
+)
+
+// errCorrupted is returned for malformed images, as EFSCORRUPTED is in Linux.
+var errCorrupted = syscall.EUCLEAN
+
+// SuperBlock is the superblock of an EROFS image.
+type SuperBlock struct {
+	Magic           uint32
+	Checksum        uint32
+	FeatureCompat   uint32
+	BlockSizeBits   uint8
+	ExtSlots        uint8
+	RootNid         uint16
+	Inodes          uint64
+	BuildTime       uint64
+	BuildTimeNsec   uint32
+	Blocks          uint32
+	MetaBlockAddr   uint32
+	XattrBlockAddr  uint32
+	UUID            [16]byte
+	VolumeName      [16]byte
+	FeatureIncompat uint32
+	ComprAlgs       uint16
+	ExtraDevices    uint16
+	DevtSlotOff     uint16
+	DirBlockBits    uint8
+}
+
+// decode decodes the superblock from b, which must be at least
+// superBlockSize bytes long.
+func (sb *SuperBlock) decode(b []byte) {
+	le := binary.LittleEndian
+	sb.Magic = le.Uint32(b[0:])
+	sb.Checksum = le.Uint32(b[4:])
+	sb.FeatureCompat = le.Uint32(b[8:])
+	sb.BlockSizeBits = b[12]
+	sb.ExtSlots = b[13]
+	sb.RootNid = le.Uint16(b[14:])
+	sb.Inodes = le.Uint64(b[16:])
+	sb.BuildTime = le.Uint64(b[24:])
+	sb.BuildTimeNsec = le.Uint32(b[32:])
+	sb.Blocks = le.Uint32(b[36:])
+	sb.MetaBlockAddr = le.Uint32(b[40:])
+	sb.XattrBlockAddr = le.Uint32(b[44:])
+	copy(sb.UUID[:], b[48:64])
+	copy(sb.VolumeName[:], b[64:80])
+	sb.FeatureIncompat = le.Uint32(b[80:])
+	sb.ComprAlgs = le.Uint16(b[84:])
+	sb.ExtraDevices = le.Uint16(b[86:])
+	sb.DevtSlotOff = le.Uint16(b[88:])
+	sb.DirBlockBits = b[90]
+}
+
+// BlockSize returns the block size of the image.
+func (sb *SuperBlock) BlockSize() uint32 {
+	return 1 << sb.BlockSizeBits
+}
+
+// Image is an EROFS image. Image is safe for concurrent use.
+type Image struct {
+	// src is the image. src is immutable.
+	src io.ReaderAt
+
+	// sb is the superblock. sb is immutable.
+	sb SuperBlock
+}
+
+// OpenImage reads the superblock of the image in src and returns the image.
+func OpenImage(src io.ReaderAt) (*Image, error) {
+	i := &Image{src: src}
+	var buf [superBlockSize]byte
+	if err := i.readFull(buf[:], SuperBlockOffset); err != nil {
+		return nil, err
+	}
+	i.sb.decode(buf[:])
+	if i.sb.Magic != SuperBlockMagicV1 {
+		return nil, syscall.EINVAL
+	}
+	if i.sb.BlockSizeBits < minBlockSizeBits || i.sb.BlockSizeBits > maxBlockSizeBits {
+		return nil, syscall.EINVAL
+	}
+	if i.sb.FeatureIncompat&^featureIncompatSupported != 0 {
+		return nil, syscall.EINVAL
+	}
+	if i.sb.FeatureIncompat&featureIncompatDeviceTable != 0 && i.sb.ExtraDevices != 0 {
+		// Data in extra devices (e.g. Nydus blobs) can't be read.
+		return nil, syscall.EOPNOTSUPP
+	}
+	if i.sb.DirBlockBits != 0 {
+		return nil, syscall.EINVAL
+	}
+	return i, nil
+}
+
+// SuperBlock returns the superblock of the image.
+func (i *Image) SuperBlock() *SuperBlock {
+	return &i.sb
+}
+
+// BlockSize returns the block size of the image.
+func (i *Image) BlockSize() uint32 {
+	return i.sb.BlockSize()
+}
+
+// RootNid returns the node ID of the root directory.
+func (i *Image) RootNid() uint64 {
+	return uint64(i.sb.RootNid)
+}
+
+// blockAddrToOffset returns the offset in the image of the given block.
+func (i *Image) blockAddrToOffset(addr uint32) int64 {
+	return int64(addr) << i.sb.BlockSizeBits
+}
+
+// readFull reads len(b) bytes at offset off of the image. Short reads are
+// reported as corruption, since all metadata must lie within the image.
+func (i *Image) readFull(b []byte, off int64) error {
+	n, err := i.src.ReadAt(b, off)
+	if n == len(b) {
+		return nil
+	}
+	if err == nil || err == io.EOF {
+		return errCorrupted
+	}
+	return err
+}
@@ -0,0 +1,357 @@
+// Copyright 2021 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package erofs
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"reflect"
+	"syscall"
+	"testing"
+)
+
+// Test images use 512-byte blocks, with the metadata area starting at block 4.
+const (
+	testBlockSizeBits = 9
+	testBlockSize     = 1 << testBlockSizeBits
+	testMetaBlock     = 4
+)
+
+// Node IDs of the test image.
+const (
+	rootNid    = 0
+	fileNid    = 8
+	linkNid    = 12
+	bigNid     = 16
+	chunkedNid = 18
+	subNid     = 24
+)
+
+// imageBuilder builds EROFS images for tests.
+type imageBuilder struct {
+	b []byte
+}
+
+func (ib *imageBuilder) at(off, n int) []byte {
+	if len(ib.b) < off+n {
+		ib.b = append(ib.b, make([]byte, off+n-len(ib.b))...)
+	}
+	return ib.b[off : off+n]
+}
+
+func (ib *imageBuilder) put16(off int, v uint16) {
+	binary.LittleEndian.PutUint16(ib.at(off, 2), v)
+}
+
+func (ib *imageBuilder) put32(off int, v uint32) {
+	binary.LittleEndian.PutUint32(ib.at(off, 4), v)
+}
+
+func (ib *imageBuilder) put64(off int, v uint64) {
+	binary.LittleEndian.PutUint64(ib.at(off, 8), v)
+}
+
+func (ib *imageBuilder) write(off int, data []byte) {
+	copy(ib.at(off, len(data)), data)
+}
+
+func (ib *imageBuilder) superBlock(rootNid uint16) {
+	const off = SuperBlockOffset
+	ib.put32(off, SuperBlockMagicV1)
+	ib.at(off+12, 1)[0] = testBlockSizeBits
+	ib.put16(off+14, rootNid)
+	ib.put64(off+16, 6)
+	ib.put64(off+24, 1000)
+	ib.put32(off+32, 5)
+	ib.put32(off+36, 64)
+	ib.put32(off+40, testMetaBlock)
+	ib.at(off, superBlockSize)
+}
+
+func inodeOff(nid int) int {
+	return testMetaBlock*testBlockSize + nid<<inodeSlotBits
+}
+
+// compactInode writes a compact inode and returns the offset following it.
+func (ib *imageBuilder) compactInode(nid int, layout uint16, mode uint16, size uint32, union uint32) int {
+	off := inodeOff(nid)
+	ib.put16(off, layout<<1)
+	ib.put16(off+4, mode)
+	ib.put16(off+6, 1)
+	ib.put32(off+8, size)
+	ib.put32(off+16, union)
+	ib.put16(off+24, 123)
+	ib.put16(off+26, 456)
+	return off + inodeCompactSize
+}
+
+// dirBlock encodes a directory block holding dirents.
+func dirBlock(dirents []Dirent, size int) []byte {
+	b := make([]byte, size)
+	nameOff := len(dirents) * direntSize
+	for j, d := range dirents {
+		binary.LittleEndian.PutUint64(b[j*direntSize:], d.Nid)
+		binary.LittleEndian.PutUint16(b[j*direntSize+8:], uint16(nameOff))
+		b[j*direntSize+10] = d.FileType
+		nameOff += copy(b[nameOff:], d.Name)
+	}
+	return b[:size]
+}
+
+var (
+	rootDirents = []Dirent{
+		{Name: ".", Nid: rootNid, FileType: FileTypeDirectory},
+		{Name: "..", Nid: rootNid, FileType: FileTypeDirectory},
+		{Name: "big", Nid: bigNid, FileType: FileTypeRegular},
+		{Name: "chunked", Nid: chunkedNid, FileType: FileTypeRegular},
+		{Name: "file", Nid: fileNid, FileType: FileTypeRegular},
+		{Name: "link", Nid: linkNid, FileType: FileTypeSymlink},
+		{Name: "sub", Nid: subNid, FileType: FileTypeDirectory},
+	}
+	subDirents = [][]Dirent{
+		{
+			{Name: ".", Nid: subNid, FileType: FileTypeDirectory},
+			{Name: "..", Nid: rootNid, FileType: FileTypeDirectory},
+			{Name: "a", Nid: fileNid, FileType: FileTypeRegular},
+		},
+		{
+			{Name: "b", Nid: fileNid, FileType: FileTypeRegular},
+		},
+	}
+	fileData = []byte("hello world\n")
+)
+
+func bigData() []byte {
+	b := make([]byte, 1300)
+	for i := range b {
+		b[i] = byte(i % 251)
+	}
+	return b
+}
+
+// newTestImage returns an image containing:
+//
+// /big: a flat plain file spanning 3 blocks.
+// /chunked: a chunk-based file with a hole, described by an extended inode.
+// /file: a file with inline data.
+// /link: a symbolic link to "file".
+// /sub: a directory spanning 2 blocks, containing hard links to /file.
+func newTestImage() []byte {
+	var ib imageBuilder
+	ib.superBlock(rootNid)
+
+	// Root directory with inline entries.
+	rootBlock := dirBlock(rootDirents, testBlockSize)
+	rootSize := 0
+	for _, d := range rootDirents {
+		rootSize += direntSize + len(d.Name)
+	}
+	off := ib.compactInode(rootNid, layoutFlatInline, syscall.S_IFDIR|0755, uint32(rootSize), 0)
+	ib.write(off, rootBlock[:rootSize])
+
+	off = ib.compactInode(fileNid, layoutFlatInline, syscall.S_IFREG|0644, uint32(len(fileData)), 0)
+	ib.write(off, fileData)
+
+	off = ib.compactInode(linkNid, layoutFlatInline, syscall.S_IFLNK|0777, 4, 0)
+	ib.write(off, []byte("file"))
+
+	const bigBlock = 16
+	big := bigData()
+	ib.compactInode(bigNid, layoutFlatPlain, syscall.S_IFREG|0600, uint32(len(big)), bigBlock)
+	ib.write(bigBlock*testBlockSize, big)
+
+	// Two 512-byte chunks; the first one is a hole.
+	off = inodeOff(chunkedNid)
+	ib.put16(off, 1|layoutChunkBased<<1)
+	ib.put16(off+4, syscall.S_IFREG|0644)
+	ib.put64(off+8, 1000)
+	ib.put32(off+16, chunkFormatIndexes)
+	ib.put32(off+24, 70000)
+	ib.put32(off+28, 80000)
+	ib.put64(off+32, 2000)
+	ib.put32(off+40, 7)
+	ib.put32(off+44, 1)
+	const chunkBlock = 24
+	ib.put32(off+inodeExtendedSize+4, nullAddr)
+	ib.put32(off+inodeExtendedSize+chunkIndexSize+4, chunkBlock)
+	ib.write(chunkBlock*testBlockSize, big[:testBlockSize])
+
+	const subBlock = 32
+	ib.compactInode(subNid, layoutFlatPlain, syscall.S_IFDIR|0700, testBlockSize+16, subBlock)
+	ib.write(subBlock*testBlockSize, dirBlock(subDirents[0], testBlockSize))
+	ib.write((subBlock+1)*testBlockSize, dirBlock(subDirents[1], 16))
+
+	ib.at(0, 64*testBlockSize)
+	return ib.b
+}
+
+func openTestImage(t *testing.T) *Image {
+	t.Helper()
+	i, err := OpenImage(bytes.NewReader(newTestImage()))
+	if err != nil {
+		t.Fatalf("OpenImage failed: %v", err)
+	}
+	return i
+}
+
+func TestOpenImage(t *testing.T) {
+	i := openTestImage(t)
+	if got := i.BlockSize(); got != testBlockSize {
+		t.Errorf("BlockSize() = %d, want %d", got, testBlockSize)
+	}
+	if got := i.RootNid(); got != rootNid {
+		t.Errorf("RootNid() = %d, want %d", got, rootNid)
+	}
+	if got := i.SuperBlock().Blocks; got != 64 {
+		t.Errorf("Blocks = %d, want 64", got)
+	}
+
+	img := newTestImage()
+	img[SuperBlockOffset] ^= 1
+	if _, err := OpenImage(bytes.NewReader(img)); err != syscall.EINVAL {
+		t.Errorf("OpenImage with bad magic: got error %v, want %v", err, syscall.EINVAL)
+	}
+	if _, err := OpenImage(bytes.NewReader(img[:SuperBlockOffset+10])); err != errCorrupted {
+		t.Errorf("OpenImage with truncated superblock: got error %v, want %v", err, errCorrupted)
+	}
+}
+
+func TestInode(t *testing.T) {
+	i := openTestImage(t)
+	for _, test := range []struct {
+		nid  uint64
+		want Inode
+	}{
+		{
+			nid:  fileNid,
+			want: Inode{Mode: syscall.S_IFREG | 0644, Nlink: 1, Size: uint64(len(fileData)), UID: 123, GID: 456, Mtime: 1000, MtimeNsec: 5},
+		},
+		{
+			nid:  chunkedNid,
+			want: Inode{Mode: syscall.S_IFREG | 0644, Nlink: 1, Size: 1000, UID: 70000, GID: 80000, Mtime: 2000, MtimeNsec: 7},
+		},
+	} {
+		in, err := i.Inode(test.nid)
+		if err != nil {
+			t.Fatalf("Inode(%d) failed: %v", test.nid, err)
+		}
+		got := Inode{Mode: in.Mode, Nlink: in.Nlink, Size: in.Size, UID: in.UID, GID: in.GID, Mtime: in.Mtime, MtimeNsec: in.MtimeNsec}
+		if got != test.want {
+			t.Errorf("Inode(%d) = %+v, want %+v", test.nid, got, test.want)
+		}
+	}
+}
+
+func readAll(t *testing.T, i *Image, nid uint64) []byte {
+	t.Helper()
+	in, err := i.Inode(nid)
+	if err != nil {
+		t.Fatalf("Inode(%d) failed: %v", nid, err)
+	}
+	buf := make([]byte, in.Size+10)
+	n, err := in.ReadAt(buf, 0)
+	if err != io.EOF {
+		t.Fatalf("ReadAt of inode %d: got error %v, want %v", nid, err, io.EOF)
+	}
+	return buf[:n]
+}
+
+func TestReadAt(t *testing.T) {
+	i := openTestImage(t)
+	big := bigData()
+
+	if got := readAll(t, i, fileNid); !bytes.Equal(got, fileData) {
+		t.Errorf("inline file data = %q, want %q", got, fileData)
+	}
+	if got := readAll(t, i, bigNid); !bytes.Equal(got, big) {
+		t.Errorf("flat plain file data mismatch")
+	}
+	wantChunked := append(make([]byte, testBlockSize), big[:1000-testBlockSize]...)
+	if got := readAll(t, i, chunkedNid); !bytes.Equal(got, wantChunked) {
+		t.Errorf("chunk-based file data mismatch")
+	}
+
+	// Unaligned reads that cross block boundaries.
+	in, err := i.Inode(bigNid)
+	if err != nil {
+		t.Fatalf("Inode failed: %v", err)
+	}
+	buf := make([]byte, 600)
+	if n, err := in.ReadAt(buf, 300); n != len(buf) || err != nil {
+		t.Fatalf("ReadAt(300) = %d, %v; want %d, nil", n, err, len(buf))
+	}
+	if !bytes.Equal(buf, big[300:900]) {
+		t.Errorf("ReadAt(300) data mismatch")
+	}
+}
+
+func TestReadlink(t *testing.T) {
+	i := openTestImage(t)
+	in, err := i.Inode(linkNid)
+	if err != nil {
+		t.Fatalf("Inode failed: %v", err)
+	}
+	if target, err := in.Readlink(); err != nil || target != "file" {
+		t.Errorf("Readlink() = %q, %v; want %q, nil", target, err, "file")
+	}
+	in, err = i.Inode(fileNid)
+	if err != nil {
+		t.Fatalf("Inode failed: %v", err)
+	}
+	if _, err := in.Readlink(); err != syscall.EINVAL {
+		t.Errorf("Readlink of regular file: got error %v, want %v", err, syscall.EINVAL)
+	}
+}
+
+func TestDirents(t *testing.T) {
+	i := openTestImage(t)
+	for _, test := range []struct {
+		nid  uint64
+		want []Dirent
+	}{
+		{nid: rootNid, want: rootDirents},
+		{nid: subNid, want: append(append([]Dirent(nil), subDirents[0]...), subDirents[1]...)},
+	} {
+		in, err := i.Inode(test.nid)
+		if err != nil {
+			t.Fatalf("Inode(%d) failed: %v", test.nid, err)
+		}
+		got, err := in.Dirents()
+		if err != nil {
+			t.Fatalf("Dirents of inode %d failed: %v", test.nid, err)
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("Dirents of inode %d = %+v, want %+v", test.nid, got, test.want)
+		}
+	}
+
+	in, err := i.Inode(fileNid)
+	if err != nil {
+		t.Fatalf("Inode failed: %v", err)
+	}
+	if _, err := in.Dirents(); err != syscall.ENOTDIR {
+		t.Errorf("Dirents of regular file: got error %v, want %v", err, syscall.ENOTDIR)
+	}
+}
+
+func TestCorruptedDirent(t *testing.T) {
+	block := dirBlock(subDirents[0], testBlockSize)
+	// Point the second name before the end of the dirent array.
+	binary.LittleEndian.PutUint16(block[direntSize+8:], direntSize)
+	if _, err := parseDirentBlock(block); err != errCorrupted {
+		t.Errorf("parseDirentBlock: got error %v, want %v", err, errCorrupted)
+	}
+}
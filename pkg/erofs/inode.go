@@ -0,0 +1,286 @@
+// Copyright 2021 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package erofs
+
+import (
+	"encoding/binary"
+	"io"
+	"syscall"
+)
+
+// Inode formats and sizes.
+const (
+	inodeSlotBits = 5
+
+	inodeCompactSize  = 32
+	inodeExtendedSize = 64
+
+	// xattrIbodyHeaderSize is the size of the header of inline xattrs.
+	xattrIbodyHeaderSize = 12
+)
+
+// Data layouts, stored in bits 1-3 of the inode format.
+const (
+	layoutFlatPlain         = 0
+	layoutCompressedFull    = 1
+	layoutFlatInline        = 2
+	layoutCompressedCompact = 3
+	layoutChunkBased        = 4
+)
+
+// Chunk formats of chunk-based files.
+const (
+	chunkFormatBlkBitsMask = 0x1f
+	chunkFormatIndexes     = 0x20
+
+	chunkIndexSize = 8
+
+	// nullAddr is the block address of holes in chunk-based files.
+	nullAddr = 0xffffffff
+)
+
+// Inode is an inode of an EROFS image.
+type Inode struct {
+	image *Image
+
+	// Nid is the node ID of the inode, which identifies it in the image.
+	Nid uint64
+
+	Mode      uint16
+	Nlink     uint32
+	Size      uint64
+	UID       uint32
+	GID       uint32
+	Mtime     uint64
+	MtimeNsec uint32
+
+	// Rdev is the device number of block and character devices, encoded as
+	// by Linux's new_encode_dev().
+	Rdev uint32
+
+	layout uint8
+
+	// dataOff is the offset of the data following the inode and its inline
+	// xattrs: the tail of inline files, or the chunk table of chunk-based
+	// files.
+	dataOff int64
+
+	// rawBlockAddr is the block address of the data of flat files.
+	rawBlockAddr uint32
+
+	// chunkFormat is the chunk format of chunk-based files.
+	chunkFormat uint16
+}
+
+// Inode reads the inode with the given node ID.
+func (i *Image) Inode(nid uint64) (*Inode, error) {
+	off := i.blockAddrToOffset(i.sb.MetaBlockAddr) + int64(nid<<inodeSlotBits)
+	var buf [inodeExtendedSize]byte
+	if err := i.readFull(buf[:inodeCompactSize], off); err != nil {
+		return nil, err
+	}
+	le := binary.LittleEndian
+	format := le.Uint16(buf[0:])
+	in := &Inode{
+		image:  i,
+		Nid:    nid,
+		Mode:   le.Uint16(buf[4:]),
+		layout: uint8(format>>1) & 0x7,
+	}
+	xattrCount := le.Uint16(buf[2:])
+	union := le.Uint32(buf[16:])
+	var size int64
+	if format&1 == 0 {
+		size = inodeCompactSize
+		in.Nlink = uint32(le.Uint16(buf[6:]))
+		in.Size = uint64(le.Uint32(buf[8:]))
+		in.UID = uint32(le.Uint16(buf[24:]))
+		in.GID = uint32(le.Uint16(buf[26:]))
+		// Compact inodes use the image's build time.
+		in.Mtime = i.sb.BuildTime
+		in.MtimeNsec = i.sb.BuildTimeNsec
+	} else {
+		size = inodeExtendedSize
+		if err := i.readFull(buf[inodeCompactSize:], off+inodeCompactSize); err != nil {
+			return nil, err
+		}
+		in.Size = le.Uint64(buf[8:])
+		in.UID = le.Uint32(buf[24:])
+		in.GID = le.Uint32(buf[28:])
+		in.Mtime = le.Uint64(buf[32:])
+		in.MtimeNsec = le.Uint32(buf[40:])
+		in.Nlink = le.Uint32(buf[44:])
+	}
+	if xattrCount != 0 {
+		size += xattrIbodyHeaderSize + int64(xattrCount-1)*4
+	}
+	in.dataOff = off + size
+
+	switch in.Mode & syscall.S_IFMT {
+	case syscall.S_IFREG, syscall.S_IFDIR, syscall.S_IFLNK:
+		switch in.layout {
+		case layoutFlatPlain, layoutFlatInline:
+			in.rawBlockAddr = union
+		case layoutChunkBased:
+			in.chunkFormat = uint16(union)
+			if in.chunkFormat&^(chunkFormatBlkBitsMask|chunkFormatIndexes) != 0 {
+				return nil, syscall.EOPNOTSUPP
+			}
+		case layoutCompressedFull, layoutCompressedCompact:
+			if in.Mode&syscall.S_IFMT != syscall.S_IFREG {
+				return nil, errCorrupted
+			}
+		default:
+			return nil, syscall.EOPNOTSUPP
+		}
+	case syscall.S_IFCHR, syscall.S_IFBLK:
+		in.Rdev = union
+	case syscall.S_IFIFO, syscall.S_IFSOCK:
+	default:
+		return nil, errCorrupted
+	}
+	return in, nil
+}
+
+// IsDir returns true if the inode is a directory.
+func (in *Inode) IsDir() bool {
+	return in.Mode&syscall.S_IFMT == syscall.S_IFDIR
+}
+
+// IsRegular returns true if the inode is a regular file.
+func (in *Inode) IsRegular() bool {
+	return in.Mode&syscall.S_IFMT == syscall.S_IFREG
+}
+
+// IsSymlink returns true if the inode is a symbolic link.
+func (in *Inode) IsSymlink() bool {
+	return in.Mode&syscall.S_IFMT == syscall.S_IFLNK
+}
+
+// Compressed returns true if the inode's data is compressed.
+func (in *Inode) Compressed() bool {
+	return in.layout == layoutCompressedFull || in.layout == layoutCompressedCompact
+}
+
+// mapBlock returns the offset in the image of the data at offset off of the
+// inode, and the number of contiguous bytes of data there. An offset of -1
+// means that the data is a hole.
+//
+// Preconditions: off < in.Size.
+func (in *Inode) mapBlock(off uint64) (int64, uint64, error) {
+	i := in.image
+	bs := uint64(i.BlockSize())
+	switch in.layout {
+	case layoutFlatPlain, layoutFlatInline:
+		nblocks := (in.Size + bs - 1) / bs
+		lastBlock := nblocks
+		if in.layout == layoutFlatInline {
+			lastBlock--
+		}
+		if off < lastBlock*bs {
+			return i.blockAddrToOffset(in.rawBlockAddr) + int64(off), lastBlock*bs - off, nil
+		}
+		// The tail is stored inline, after the inode. It must not cross a
+		// block boundary.
+		tailOff := in.dataOff + int64(off%bs)
+		tailLen := in.Size - off
+		if uint64(tailOff)%bs+tailLen > bs {
+			return 0, 0, errCorrupted
+		}
+		return tailOff, tailLen, nil
+
+	case layoutChunkBased:
+		chunkBits := uint(in.chunkFormat&chunkFormatBlkBitsMask) + uint(i.sb.BlockSizeBits)
+		chunk := off >> chunkBits
+		chunkOff := off & (1<<chunkBits - 1)
+		chunkLen := uint64(1)<<chunkBits - chunkOff
+		var addr uint32
+		if in.chunkFormat&chunkFormatIndexes != 0 {
+			// The table of struct erofs_inode_chunk_index is 8-byte aligned.
+			var buf [chunkIndexSize]byte
+			tableOff := (in.dataOff + chunkIndexSize - 1) &^ (chunkIndexSize - 1)
+			if err := i.readFull(buf[:], tableOff+int64(chunk)*chunkIndexSize); err != nil {
+				return 0, 0, err
+			}
+			if deviceID := binary.LittleEndian.Uint16(buf[2:]); deviceID != 0 {
+				return 0, 0, syscall.EOPNOTSUPP
+			}
+			addr = binary.LittleEndian.Uint32(buf[4:])
+		} else {
+			var buf [4]byte
+			tableOff := (in.dataOff + 3) &^ 3
+			if err := i.readFull(buf[:], tableOff+int64(chunk)*4); err != nil {
+				return 0, 0, err
+			}
+			addr = binary.LittleEndian.Uint32(buf[:])
+		}
+		if addr == nullAddr {
+			return -1, chunkLen, nil
+		}
+		return i.blockAddrToOffset(addr) + int64(chunkOff), chunkLen, nil
+
+	default:
+		return 0, 0, syscall.EOPNOTSUPP
+	}
+}
+
+// ReadAt implements io.ReaderAt.ReadAt for the data of the inode.
+func (in *Inode) ReadAt(p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, syscall.EINVAL
+	}
+	var done int
+	for done < len(p) {
+		pos := uint64(off) + uint64(done)
+		if pos >= in.Size {
+			return done, io.EOF
+		}
+		imageOff, n, err := in.mapBlock(pos)
+		if err != nil {
+			return done, err
+		}
+		if rem := in.Size - pos; n > rem {
+			n = rem
+		}
+		if rem := uint64(len(p) - done); n > rem {
+			n = rem
+		}
+		dst := p[done : done+int(n)]
+		if imageOff < 0 {
+			for j := range dst {
+				dst[j] = 0
+			}
+		} else if err := in.image.readFull(dst, imageOff); err != nil {
+			return done, err
+		}
+		done += int(n)
+	}
+	return done, nil
+}
+
+// Readlink returns the target of a symbolic link.
+func (in *Inode) Readlink() (string, error) {
+	if !in.IsSymlink() {
+		return "", syscall.EINVAL
+	}
+	if in.Size > syscall.PathMax {
+		return "", errCorrupted
+	}
+	buf := make([]byte, in.Size)
+	if _, err := in.ReadAt(buf, 0); err != nil && err != io.EOF {
+		return "", err
+	}
+	return string(buf), nil
+}
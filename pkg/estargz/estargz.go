@@ -0,0 +1,384 @@
+// Copyright 2021 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package estargz reads eStargz layer blobs.
+//
+// An eStargz blob is a gzip-compressed tar archive, as used for the layers of
+// container images, in which the contents of every file (or of every chunk of
+// large files) start a new gzip member. The blob ends with a table of
+// contents (TOC) that gives the offsets of these members, followed by a
+// footer that gives the offset of the TOC. This allows individual files to be
+// read from the blob with range requests, without fetching the whole layer.
+// Legacy stargz blobs, which have a shorter footer, are also supported.
+//
+// See https://github.com/containerd/stargz-snapshotter/blob/main/docs/estargz.md.
+package estargz
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	// FooterSize is the size of the footer of eStargz blobs.
+	FooterSize = 51
+
+	// legacyFooterSize is the size of the footer of legacy stargz blobs.
+	legacyFooterSize = 47
+
+	// TOCTarName is the name of the TOC in the tar stream.
+	TOCTarName = "stargz.index.json"
+
+	// TOCDigestAnnotation is the annotation of layer descriptors that holds
+	// the digest of the TOC's JSON.
+	TOCDigestAnnotation = "containerd.io/snapshot/stargz/toc.digest"
+
+	// PrefetchLandmark is the name of the entry that follows the files that
+	// should be prefetched when the layer is used.
+	PrefetchLandmark = ".prefetch.landmark"
+
+	// NoPrefetchLandmark is the name of the entry that marks layers with no
+	// files to prefetch.
+	NoPrefetchLandmark = ".no.prefetch.landmark"
+
+	// maxTOCSize is the maximum size of a TOC's JSON.
+	maxTOCSize = 64 << 20
+)
+
+// Types of TOC entries.
+const (
+	TypeDir      = "dir"
+	TypeReg      = "reg"
+	TypeSymlink  = "symlink"
+	TypeHardlink = "hardlink"
+	TypeChar     = "char"
+	TypeBlock    = "block"
+	TypeFIFO     = "fifo"
+	TypeChunk    = "chunk"
+)
+
+// TOC is the table of contents of a blob.
+type TOC struct {
+	// Version is the version of the TOC format, which is always 1.
+	Version int `json:"version"`
+
+	// Entries are the entries of the blob, in the order of the tar stream.
+	Entries []*Entry `json:"entries"`
+
+	// Digest is the digest of the TOC's JSON, as "sha256:<hex>".
+	Digest string `json:"-"`
+
+	// Offset is the offset of the TOC in the blob.
+	Offset int64 `json:"-"`
+}
+
+// Entry is an entry of a TOC.
+type Entry struct {
+	Name        string            `json:"name"`
+	Type        string            `json:"type"`
+	Size        int64             `json:"size,omitempty"`
+	ModTime3339 string            `json:"modtime,omitempty"`
+	LinkName    string            `json:"linkName,omitempty"`
+	Mode        int64             `json:"mode,omitempty"`
+	UID         int               `json:"uid,omitempty"`
+	GID         int               `json:"gid,omitempty"`
+	DevMajor    int               `json:"devMajor,omitempty"`
+	DevMinor    int               `json:"devMinor,omitempty"`
+	Xattrs      map[string][]byte `json:"xattrs,omitempty"`
+	Digest      string            `json:"digest,omitempty"`
+	Offset      int64             `json:"offset,omitempty"`
+	ChunkOffset int64             `json:"chunkOffset,omitempty"`
+	ChunkSize   int64             `json:"chunkSize,omitempty"`
+	ChunkDigest string            `json:"chunkDigest,omitempty"`
+	InnerOffset int64             `json:"innerOffset,omitempty"`
+
+	// chunks are the chunks of regular files, ordered by their offset in
+	// the file.
+	chunks []Chunk
+}
+
+// Chunk is a part of the contents of a regular file, which is stored at the
+// start of a gzip member of the blob.
+type Chunk struct {
+	// Offset is the offset of the chunk's gzip member in the blob.
+	Offset int64
+
+	// CompressedSize is the number of bytes from Offset to the next gzip
+	// member that starts a chunk or the TOC. Reading them is enough to
+	// decompress the chunk.
+	CompressedSize int64
+
+	// InnerOffset is the offset of the chunk in the decompressed member.
+	InnerOffset int64
+
+	// FileOffset is the offset of the chunk in the file.
+	FileOffset int64
+
+	// Size is the size of the chunk.
+	Size int64
+
+	// Digest is the digest of the chunk's contents, as "sha256:<hex>", or
+	// empty if the TOC doesn't have one.
+	Digest string
+}
+
+// CleanName returns the name of entries as a relative path with no leading
+// "./" or "/", or "" for the root directory.
+func CleanName(name string) string {
+	name = path.Clean("/" + name)
+	return strings.TrimPrefix(name, "/")
+}
+
+// Chunks returns the chunks of a regular file.
+func (e *Entry) Chunks() []Chunk {
+	return e.chunks
+}
+
+// ParseFooter returns the offset of the TOC from the footer at the end of
+// tail, which must hold at least the last FooterSize bytes of the blob. It
+// also returns the size of the footer, which is shorter for legacy stargz
+// blobs.
+func ParseFooter(tail []byte) (int64, int64, error) {
+	if len(tail) >= FooterSize {
+		if off, err := parseFooter(tail[len(tail)-FooterSize:]); err == nil {
+			return off, FooterSize, nil
+		}
+	}
+	if len(tail) >= legacyFooterSize {
+		if off, err := parseFooter(tail[len(tail)-legacyFooterSize:]); err == nil {
+			return off, legacyFooterSize, nil
+		}
+	}
+	return 0, 0, fmt.Errorf("no eStargz footer found")
+}
+
+// parseFooter parses a footer, which is an empty gzip member whose extra
+// field holds the TOC offset as "%016xSTARGZ". eStargz puts it in a subfield
+// with ID "SG", while legacy stargz uses it as the whole extra field.
+func parseFooter(b []byte) (int64, error) {
+	zr, err := gzip.NewReader(bytes.NewReader(b))
+	if err != nil {
+		return 0, err
+	}
+	defer zr.Close()
+	extra := zr.Header.Extra
+	if len(extra) == 26 && extra[0] == 'S' && extra[1] == 'G' && binary.LittleEndian.Uint16(extra[2:]) == 22 {
+		extra = extra[4:]
+	}
+	if len(extra) != 22 || string(extra[16:]) != "STARGZ" {
+		return 0, fmt.Errorf("invalid footer extra field %q", extra)
+	}
+	off, err := strconv.ParseInt(string(extra[:16]), 16, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid TOC offset in footer: %v", err)
+	}
+	return off, nil
+}
+
+// ReadTOC reads the TOC of the blob of the given size from r.
+func ReadTOC(r io.ReaderAt, size int64) (*TOC, error) {
+	tailSize := int64(FooterSize)
+	if size < tailSize {
+		tailSize = size
+	}
+	tail := make([]byte, tailSize)
+	if _, err := r.ReadAt(tail, size-tailSize); err != nil && err != io.EOF {
+		return nil, fmt.Errorf("reading footer: %v", err)
+	}
+	tocOff, footerSize, err := ParseFooter(tail)
+	if err != nil {
+		return nil, err
+	}
+	if tocOff < 0 || tocOff > size-footerSize {
+		return nil, fmt.Errorf("TOC offset %d out of range of blob of size %d", tocOff, size)
+	}
+	return DecodeTOC(io.NewSectionReader(r, tocOff, size-footerSize-tocOff), tocOff)
+}
+
+// DecodeTOC decodes the TOC at the given offset of a blob from r, which
+// holds its gzip member.
+func DecodeTOC(r io.Reader, tocOff int64) (*TOC, error) {
+	zr, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, fmt.Errorf("decompressing TOC: %v", err)
+	}
+	defer zr.Close()
+	tr := tar.NewReader(zr)
+	hdr, err := tr.Next()
+	if err != nil {
+		return nil, fmt.Errorf("reading TOC: %v", err)
+	}
+	if hdr.Name != TOCTarName {
+		return nil, fmt.Errorf("TOC has name %q, want %q", hdr.Name, TOCTarName)
+	}
+	if hdr.Size > maxTOCSize {
+		return nil, fmt.Errorf("TOC too large: %d bytes", hdr.Size)
+	}
+	data, err := ioutil.ReadAll(tr)
+	if err != nil {
+		return nil, fmt.Errorf("reading TOC: %v", err)
+	}
+	toc := &TOC{}
+	if err := json.Unmarshal(data, toc); err != nil {
+		return nil, fmt.Errorf("parsing TOC: %v", err)
+	}
+	sum := sha256.Sum256(data)
+	toc.Digest = "sha256:" + hex.EncodeToString(sum[:])
+	toc.Offset = tocOff
+	if err := toc.resolveChunks(); err != nil {
+		return nil, err
+	}
+	return toc, nil
+}
+
+// resolveChunks computes the chunks of regular files.
+func (t *TOC) resolveChunks() error {
+	// The compressed data of a chunk ends at the next gzip member that
+	// starts a chunk, or at the TOC.
+	ends := []int64{t.Offset}
+	for _, e := range t.Entries {
+		if (e.Type == TypeReg || e.Type == TypeChunk) && e.Offset > 0 {
+			ends = append(ends, e.Offset)
+		}
+	}
+	sort.Slice(ends, func(i, j int) bool { return ends[i] < ends[j] })
+	end := func(off int64) int64 {
+		i := sort.Search(len(ends), func(i int) bool { return ends[i] > off })
+		if i == len(ends) {
+			return t.Offset
+		}
+		return ends[i]
+	}
+
+	var last *Entry
+	for _, e := range t.Entries {
+		switch e.Type {
+		case TypeReg:
+			last = e
+		case TypeChunk:
+			if last == nil || CleanName(e.Name) != CleanName(last.Name) {
+				return fmt.Errorf("chunk of %q doesn't follow its file", e.Name)
+			}
+		default:
+			last = nil
+			continue
+		}
+		if last.Size == 0 {
+			continue
+		}
+		if e.Offset <= 0 || e.Offset >= t.Offset {
+			return fmt.Errorf("entry %q has invalid offset %d", e.Name, e.Offset)
+		}
+		c := Chunk{
+			Offset:      e.Offset,
+			InnerOffset: e.InnerOffset,
+			FileOffset:  e.ChunkOffset,
+			Size:        e.ChunkSize,
+			Digest:      e.ChunkDigest,
+		}
+		c.CompressedSize = end(c.Offset) - c.Offset
+		if c.Size == 0 {
+			// The last chunk extends to the end of the file.
+			c.Size = last.Size - c.FileOffset
+		}
+		if c.FileOffset < 0 || c.Size <= 0 || c.FileOffset+c.Size > last.Size {
+			return fmt.Errorf("entry %q has invalid chunk [%d, +%d) for size %d", e.Name, c.FileOffset, c.Size, last.Size)
+		}
+		if n := len(last.chunks); n > 0 {
+			if prev := last.chunks[n-1]; prev.FileOffset+prev.Size != c.FileOffset {
+				return fmt.Errorf("entry %q has non-contiguous chunks", e.Name)
+			}
+		} else if c.FileOffset != 0 {
+			return fmt.Errorf("entry %q has no chunk at offset 0", e.Name)
+		}
+		last.chunks = append(last.chunks, c)
+	}
+	for _, e := range t.Entries {
+		if e.Type != TypeReg || e.Size == 0 {
+			continue
+		}
+		n := len(e.chunks)
+		if n == 0 {
+			return fmt.Errorf("entry %q has no chunks", e.Name)
+		}
+		if c := e.chunks[n-1]; c.FileOffset+c.Size != e.Size {
+			return fmt.Errorf("chunks of entry %q end at %d, want %d", e.Name, c.FileOffset+c.Size, e.Size)
+		}
+	}
+	return nil
+}
+
+// PrefetchOffset returns the offset in the blob up to which chunks should be
+// prefetched, which is the offset of the prefetch landmark, or 0 if the blob
+// has no landmark.
+func (t *TOC) PrefetchOffset() int64 {
+	for _, e := range t.Entries {
+		if CleanName(e.Name) == PrefetchLandmark {
+			return e.Offset
+		}
+	}
+	return 0
+}
+
+// DecompressChunk decompresses the chunk c from data, which holds the
+// compressed bytes of the chunk read from the blob, and verifies its digest.
+func DecompressChunk(data []byte, c Chunk) ([]byte, error) {
+	zr, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("decompressing chunk at %d: %v", c.Offset, err)
+	}
+	defer zr.Close()
+	if c.InnerOffset > 0 {
+		if _, err := io.CopyN(ioutil.Discard, zr, c.InnerOffset); err != nil {
+			return nil, fmt.Errorf("decompressing chunk at %d: %v", c.Offset, err)
+		}
+	}
+	buf := make([]byte, c.Size)
+	if _, err := io.ReadFull(zr, buf); err != nil {
+		return nil, fmt.Errorf("decompressing chunk at %d: %v", c.Offset, err)
+	}
+	if err := VerifyDigest(buf, c.Digest); err != nil {
+		return nil, fmt.Errorf("chunk at %d: %v", c.Offset, err)
+	}
+	return buf, nil
+}
+
+// VerifyDigest returns an error if data doesn't match digest. Empty digests
+// match any data.
+func VerifyDigest(data []byte, digest string) error {
+	if digest == "" {
+		return nil
+	}
+	const prefix = "sha256:"
+	if !strings.HasPrefix(digest, prefix) {
+		return fmt.Errorf("unsupported digest %q", digest)
+	}
+	sum := sha256.Sum256(data)
+	if got := hex.EncodeToString(sum[:]); got != digest[len(prefix):] {
+		return fmt.Errorf("digest mismatch: got sha256:%s, want %s", got, digest)
+	}
+	return nil
+}
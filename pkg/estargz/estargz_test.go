@@ -0,0 +1,293 @@
+// Copyright 2021 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package estargz
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// testFile is a file to put in a test blob.
+type testFile struct {
+	name      string
+	typ       string
+	data      string
+	chunkSize int
+}
+
+// memberWriter forwards writes to the gzip member being written.
+type memberWriter struct {
+	buf bytes.Buffer
+	zw  *gzip.Writer
+}
+
+func (w *memberWriter) Write(p []byte) (int, error) {
+	return w.zw.Write(p)
+}
+
+// newMember ends the current gzip member and starts a new one, returning its
+// offset.
+func (w *memberWriter) newMember(t *testing.T) int64 {
+	if w.zw != nil {
+		if err := w.zw.Close(); err != nil {
+			t.Fatalf("closing gzip member: %v", err)
+		}
+	}
+	w.zw = gzip.NewWriter(&w.buf)
+	return int64(w.buf.Len())
+}
+
+func digestOf(data []byte) string {
+	sum := sha256.Sum256(data)
+	return "sha256:" + hex.EncodeToString(sum[:])
+}
+
+// footer returns the footer that points to the TOC at tocOff. It is built by
+// hand since the size of the empty deflate stream written by compress/flate
+// depends on the Go version.
+func footer(tocOff int64, legacy bool) []byte {
+	payload := []byte(fmt.Sprintf("%016xSTARGZ", tocOff))
+	extra := payload
+	if !legacy {
+		extra = []byte{'S', 'G', 0, 0}
+		binary.LittleEndian.PutUint16(extra[2:], uint16(len(payload)))
+		extra = append(extra, payload...)
+	}
+	// Header with FEXTRA set, no modification time and an unknown OS.
+	b := []byte{0x1f, 0x8b, 8, 4, 0, 0, 0, 0, 0, 0xff, 0, 0}
+	binary.LittleEndian.PutUint16(b[10:], uint16(len(extra)))
+	b = append(b, extra...)
+	// An empty stored block, then the CRC-32 and size of the empty contents.
+	b = append(b, 1, 0, 0, 0xff, 0xff)
+	return append(b, 0, 0, 0, 0, 0, 0, 0, 0)
+}
+
+// buildBlob builds an eStargz blob with files, in the way eStargz writers
+// do: the contents of every chunk start a new gzip member.
+func buildBlob(t *testing.T, files []testFile, legacy bool) []byte {
+	w := &memberWriter{}
+	w.newMember(t)
+	tw := tar.NewWriter(w)
+	toc := &TOC{Version: 1}
+	for _, f := range files {
+		hdr := &tar.Header{Name: f.name, Mode: 0644}
+		e := &Entry{Name: f.name, Type: f.typ, Mode: 0644}
+		switch f.typ {
+		case TypeDir:
+			hdr.Typeflag = tar.TypeDir
+		case TypeSymlink:
+			hdr.Typeflag = tar.TypeSymlink
+			hdr.Linkname = f.data
+			e.LinkName = f.data
+		case TypeReg:
+			hdr.Typeflag = tar.TypeReg
+			hdr.Size = int64(len(f.data))
+			e.Size = hdr.Size
+			e.Digest = digestOf([]byte(f.data))
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			t.Fatalf("writing header of %q: %v", f.name, err)
+		}
+		toc.Entries = append(toc.Entries, e)
+		if f.typ != TypeReg || len(f.data) == 0 {
+			continue
+		}
+		chunkSize := f.chunkSize
+		if chunkSize == 0 {
+			chunkSize = len(f.data)
+		}
+		for off := 0; off < len(f.data); off += chunkSize {
+			end := off + chunkSize
+			if end > len(f.data) {
+				end = len(f.data)
+			}
+			chunk := f.data[off:end]
+			ce := e
+			if off > 0 {
+				ce = &Entry{Name: f.name, Type: TypeChunk}
+				toc.Entries = append(toc.Entries, ce)
+			}
+			ce.Offset = w.newMember(t)
+			ce.ChunkOffset = int64(off)
+			if f.chunkSize != 0 {
+				ce.ChunkSize = int64(len(chunk))
+			}
+			ce.ChunkDigest = digestOf([]byte(chunk))
+			if _, err := tw.Write([]byte(chunk)); err != nil {
+				t.Fatalf("writing %q: %v", f.name, err)
+			}
+		}
+		w.newMember(t)
+	}
+	if err := tw.Flush(); err != nil {
+		t.Fatalf("flushing tar: %v", err)
+	}
+
+	tocOff := w.newMember(t)
+	data, err := json.Marshal(toc)
+	if err != nil {
+		t.Fatalf("marshaling TOC: %v", err)
+	}
+	ttw := tar.NewWriter(w)
+	if err := ttw.WriteHeader(&tar.Header{Name: TOCTarName, Typeflag: tar.TypeReg, Size: int64(len(data))}); err != nil {
+		t.Fatalf("writing TOC header: %v", err)
+	}
+	if _, err := ttw.Write(data); err != nil {
+		t.Fatalf("writing TOC: %v", err)
+	}
+	if err := ttw.Close(); err != nil {
+		t.Fatalf("closing TOC tar: %v", err)
+	}
+	if err := w.zw.Close(); err != nil {
+		t.Fatalf("closing TOC member: %v", err)
+	}
+	return append(w.buf.Bytes(), footer(tocOff, legacy)...)
+}
+
+var testFiles = []testFile{
+	{name: "etc", typ: TypeDir},
+	{name: "etc/hostname", typ: TypeReg, data: "sandbox\n"},
+	{name: "empty", typ: TypeReg},
+	{name: PrefetchLandmark, typ: TypeReg, data: "\x00"},
+	{name: "bin/large", typ: TypeReg, data: strings.Repeat("0123456789", 100), chunkSize: 128},
+	{name: "bin/link", typ: TypeSymlink, data: "large"},
+}
+
+func TestFooterSize(t *testing.T) {
+	if got := len(footer(1234, false)); got != FooterSize {
+		t.Errorf("footer size: got %d, want %d", got, FooterSize)
+	}
+	if got := len(footer(1234, true)); got != legacyFooterSize {
+		t.Errorf("legacy footer size: got %d, want %d", got, legacyFooterSize)
+	}
+}
+
+func TestParseFooter(t *testing.T) {
+	for _, legacy := range []bool{false, true} {
+		t.Run(fmt.Sprintf("legacy=%t", legacy), func(t *testing.T) {
+			tail := append([]byte("leading data"), footer(0xabcdef, legacy)...)
+			off, size, err := ParseFooter(tail)
+			if err != nil {
+				t.Fatalf("ParseFooter failed: %v", err)
+			}
+			if off != 0xabcdef {
+				t.Errorf("TOC offset: got %#x, want %#x", off, 0xabcdef)
+			}
+			want := int64(FooterSize)
+			if legacy {
+				want = legacyFooterSize
+			}
+			if size != want {
+				t.Errorf("footer size: got %d, want %d", size, want)
+			}
+		})
+	}
+
+	if _, _, err := ParseFooter(bytes.Repeat([]byte{0}, FooterSize)); err == nil {
+		t.Errorf("ParseFooter succeeded on zeroes")
+	}
+}
+
+func TestReadTOC(t *testing.T) {
+	for _, legacy := range []bool{false, true} {
+		t.Run(fmt.Sprintf("legacy=%t", legacy), func(t *testing.T) {
+			blob := buildBlob(t, testFiles, legacy)
+			toc, err := ReadTOC(bytes.NewReader(blob), int64(len(blob)))
+			if err != nil {
+				t.Fatalf("ReadTOC failed: %v", err)
+			}
+
+			files := make(map[string]*Entry)
+			for _, e := range toc.Entries {
+				if e.Type != TypeChunk {
+					files[CleanName(e.Name)] = e
+				}
+			}
+			for _, f := range testFiles {
+				e, ok := files[f.name]
+				if !ok {
+					t.Errorf("missing entry %q", f.name)
+					continue
+				}
+				if f.typ != TypeReg {
+					continue
+				}
+				var got []byte
+				for _, c := range e.Chunks() {
+					data, err := DecompressChunk(blob[c.Offset:c.Offset+c.CompressedSize], c)
+					if err != nil {
+						t.Fatalf("DecompressChunk(%q, %+v) failed: %v", f.name, c, err)
+					}
+					got = append(got, data...)
+				}
+				if string(got) != f.data {
+					t.Errorf("contents of %q: got %q, want %q", f.name, got, f.data)
+				}
+			}
+			if got := len(files["bin/large"].Chunks()); got != 8 {
+				t.Errorf("chunks of bin/large: got %d, want 8", got)
+			}
+			if got, want := toc.PrefetchOffset(), files[PrefetchLandmark].Offset; got != want || got == 0 {
+				t.Errorf("PrefetchOffset: got %d, want %d", got, want)
+			}
+		})
+	}
+}
+
+func TestDecompressChunkDigest(t *testing.T) {
+	blob := buildBlob(t, testFiles, false)
+	toc, err := ReadTOC(bytes.NewReader(blob), int64(len(blob)))
+	if err != nil {
+		t.Fatalf("ReadTOC failed: %v", err)
+	}
+	for _, e := range toc.Entries {
+		if CleanName(e.Name) != "etc/hostname" {
+			continue
+		}
+		c := e.Chunks()[0]
+		c.Digest = digestOf([]byte("something else"))
+		if _, err := DecompressChunk(blob[c.Offset:c.Offset+c.CompressedSize], c); err == nil {
+			t.Errorf("DecompressChunk succeeded with the wrong digest")
+		}
+		return
+	}
+	t.Fatalf("etc/hostname not found")
+}
+
+func TestCleanName(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		want string
+	}{
+		{"", ""},
+		{"./", ""},
+		{"/", ""},
+		{"./etc/passwd", "etc/passwd"},
+		{"etc/", "etc"},
+		{"/usr/../bin//sh", "bin/sh"},
+	} {
+		if got := CleanName(tc.name); got != tc.want {
+			t.Errorf("CleanName(%q): got %q, want %q", tc.name, got, tc.want)
+		}
+	}
+}
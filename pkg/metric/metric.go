@@ -72,6 +72,9 @@ func Initialize() {
 	for _, v := range allMetrics.m {
 		m.Metrics = append(m.Metrics, v.metadata)
 	}
+	for _, v := range allMetrics.distributions {
+		m.Metrics = append(m.Metrics, v.metadata)
+	}
 	eventchannel.Emit(&m)
 }
 
@@ -114,7 +117,7 @@ func RegisterCustomUint64Metric(name string, cumulative, sync bool, units pb.Met
 		return ErrInitializationDone
 	}
 
-	if _, ok := allMetrics.m[name]; ok {
+	if allMetrics.has(name) {
 		return ErrNameInUse
 	}
 
@@ -184,29 +187,149 @@ func (m *Uint64Metric) IncrementBy(v uint64) {
 	atomic.AddUint64(&m.value, v)
 }
 
+// DistributionMetric counts samples, such as latencies, in buckets.
+//
+// Like Uint64Metric, it is not saved across save/restore.
+type DistributionMetric struct {
+	// metadata describes the metric. It is immutable.
+	metadata *pb.MetricMetadata
+
+	// bounds are the exclusive upper bounds of all but the last bucket. It
+	// is immutable.
+	bounds []int64
+
+	// counts are the numbers of samples in each bucket. They must be
+	// accessed atomically.
+	counts []uint64
+}
+
+// NewDistributionMetric creates and registers a new distribution metric with
+// the given name. bounds are the exclusive upper bounds of all but the last
+// bucket, and must be increasing.
+//
+// Preconditions:
+// * name must be globally unique.
+// * Initialize/Disable have not been called.
+func NewDistributionMetric(name string, sync bool, units pb.MetricMetadata_Units, bounds []int64, description string) (*DistributionMetric, error) {
+	if initialized {
+		return nil, ErrInitializationDone
+	}
+	if allMetrics.has(name) {
+		return nil, ErrNameInUse
+	}
+	if len(bounds) == 0 {
+		return nil, fmt.Errorf("distribution metric %q has no buckets", name)
+	}
+	for i := 1; i < len(bounds); i++ {
+		if bounds[i] <= bounds[i-1] {
+			return nil, fmt.Errorf("distribution metric %q has bucket bounds that aren't increasing: %v", name, bounds)
+		}
+	}
+
+	bounds = append([]int64(nil), bounds...)
+	d := &DistributionMetric{
+		metadata: &pb.MetricMetadata{
+			Name:         name,
+			Description:  description,
+			Cumulative:   true,
+			Sync:         sync,
+			Type:         pb.MetricMetadata_TYPE_DISTRIBUTION,
+			Units:        units,
+			BucketBounds: bounds,
+		},
+		bounds: bounds,
+		counts: make([]uint64, len(bounds)+1),
+	}
+	allMetrics.distributions[name] = d
+	return d, nil
+}
+
+// MustCreateNewDistributionMetric calls NewDistributionMetric and panics if it
+// returns an error.
+func MustCreateNewDistributionMetric(name string, sync bool, units pb.MetricMetadata_Units, bounds []int64, description string) *DistributionMetric {
+	d, err := NewDistributionMetric(name, sync, units, bounds, description)
+	if err != nil {
+		panic(fmt.Sprintf("Unable to create metric %q: %v", name, err))
+	}
+	return d
+}
+
+// AddSample adds a sample to the bucket that contains it.
+func (d *DistributionMetric) AddSample(sample int64) {
+	i := sort.Search(len(d.bounds), func(i int) bool {
+		return sample < d.bounds[i]
+	})
+	atomic.AddUint64(&d.counts[i], 1)
+}
+
+// Bounds returns the exclusive upper bounds of all but the last bucket.
+func (d *DistributionMetric) Bounds() []int64 {
+	return append([]int64(nil), d.bounds...)
+}
+
+// BucketCounts returns the numbers of samples in each bucket.
+func (d *DistributionMetric) BucketCounts() []uint64 {
+	counts := make([]uint64, len(d.counts))
+	for i := range d.counts {
+		counts[i] = atomic.LoadUint64(&d.counts[i])
+	}
+	return counts
+}
+
 // metricSet holds named metrics.
 type metricSet struct {
-	m map[string]customUint64Metric
+	m             map[string]customUint64Metric
+	distributions map[string]*DistributionMetric
 }
 
 // makeMetricSet returns a new metricSet.
 func makeMetricSet() metricSet {
 	return metricSet{
-		m: make(map[string]customUint64Metric),
+		m:             make(map[string]customUint64Metric),
+		distributions: make(map[string]*DistributionMetric),
 	}
 }
 
+// has returns true if m contains a metric with the given name.
+func (m *metricSet) has(name string) bool {
+	_, isUint64 := m.m[name]
+	_, isDistribution := m.distributions[name]
+	return isUint64 || isDistribution
+}
+
 // Values returns a snapshot of all values in m.
 func (m *metricSet) Values() metricValues {
-	vals := make(metricValues)
+	vals := metricValues{
+		m:             make(map[string]uint64),
+		distributions: make(map[string][]uint64),
+	}
 	for k, v := range m.m {
-		vals[k] = v.value()
+		vals.m[k] = v.value()
+	}
+	for k, v := range m.distributions {
+		vals.distributions[k] = v.BucketCounts()
 	}
 	return vals
 }
 
 // metricValues contains a copy of the values of all metrics.
-type metricValues map[string]uint64
+type metricValues struct {
+	m             map[string]uint64
+	distributions map[string][]uint64
+}
+
+// equalCounts returns true if a and b are the same bucket counts.
+func equalCounts(a, b []uint64) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
 
 var (
 	// emitMu protects metricsAtLastEmit and ensures that all emitted
@@ -233,16 +356,24 @@ func EmitMetricUpdate() {
 	snapshot := allMetrics.Values()
 
 	m := pb.MetricUpdate{}
-	for k, v := range snapshot {
+	for k, v := range snapshot.m {
 		// On the first call metricsAtLastEmit will be empty. Include
 		// all metrics then.
-		if prev, ok := metricsAtLastEmit[k]; !ok || prev != v {
+		if prev, ok := metricsAtLastEmit.m[k]; !ok || prev != v {
 			m.Metrics = append(m.Metrics, &pb.MetricValue{
 				Name:  k,
 				Value: &pb.MetricValue_Uint64Value{v},
 			})
 		}
 	}
+	for k, v := range snapshot.distributions {
+		if prev, ok := metricsAtLastEmit.distributions[k]; !ok || !equalCounts(prev, v) {
+			m.Metrics = append(m.Metrics, &pb.MetricValue{
+				Name:  k,
+				Value: &pb.MetricValue_DistributionValue{&pb.Distribution{BucketCounts: v}},
+			})
+		}
+	}
 
 	metricsAtLastEmit = snapshot
 	if len(m.Metrics) == 0 {
@@ -55,6 +55,7 @@ func init() {
 func reset() {
 	initialized = false
 	allMetrics = makeMetricSet()
+	metricsAtLastEmit = metricValues{}
 	emitter.Reset()
 }
 
@@ -256,3 +257,64 @@ func TestEmitMetricUpdate(t *testing.T) {
 		t.Errorf("%v: Value got %v want 1", m, uv.Uint64Value)
 	}
 }
+
+func TestDistributionMetric(t *testing.T) {
+	defer reset()
+
+	d, err := NewDistributionMetric("/dist", false, pb.MetricMetadata_UNITS_NANOSECONDS, []int64{10, 100}, fooDescription)
+	if err != nil {
+		t.Fatalf("NewDistributionMetric got err %v want nil", err)
+	}
+	if _, err := NewUint64Metric("/dist", false, pb.MetricMetadata_UNITS_NONE, barDescription); err != ErrNameInUse {
+		t.Errorf("NewUint64Metric with name of distribution got err %v want %v", err, ErrNameInUse)
+	}
+	if _, err := NewDistributionMetric("/bad", false, pb.MetricMetadata_UNITS_NONE, []int64{10, 10}, barDescription); err == nil {
+		t.Errorf("NewDistributionMetric with non-increasing bounds got nil err")
+	}
+
+	Initialize()
+
+	mr, ok := emitter[0].(*pb.MetricRegistration)
+	if !ok {
+		t.Fatalf("emitter %v got %T want pb.MetricRegistration", emitter[0], emitter[0])
+	}
+	if len(mr.Metrics) != 1 {
+		t.Fatalf("MetricRegistration got %d metrics want 1", len(mr.Metrics))
+	}
+	if m := mr.Metrics[0]; m.Type != pb.MetricMetadata_TYPE_DISTRIBUTION || len(m.BucketBounds) != 2 {
+		t.Errorf("Metadata %+v got Type %v and %d bucket bounds want %v and 2", m, m.Type, len(m.BucketBounds), pb.MetricMetadata_TYPE_DISTRIBUTION)
+	}
+
+	for _, sample := range []int64{-1, 9, 10, 99, 100, 1000} {
+		d.AddSample(sample)
+	}
+	want := []uint64{2, 2, 2}
+
+	emitter.Reset()
+	EmitMetricUpdate()
+
+	if len(emitter) != 1 {
+		t.Fatalf("EmitMetricUpdate emitted %d events want 1", len(emitter))
+	}
+	update, ok := emitter[0].(*pb.MetricUpdate)
+	if !ok {
+		t.Fatalf("emitter %v got %T want pb.MetricUpdate", emitter[0], emitter[0])
+	}
+	if len(update.Metrics) != 1 {
+		t.Fatalf("MetricUpdate got %d metrics want 1", len(update.Metrics))
+	}
+	dv, ok := update.Metrics[0].Value.(*pb.MetricValue_DistributionValue)
+	if !ok {
+		t.Fatalf("%+v: value got %T want pb.MetricValue_DistributionValue", update.Metrics[0], update.Metrics[0].Value)
+	}
+	if got := dv.DistributionValue.BucketCounts; !equalCounts(got, want) {
+		t.Errorf("BucketCounts got %v want %v", got, want)
+	}
+
+	// The distribution is unchanged, so it isn't included again.
+	emitter.Reset()
+	EmitMetricUpdate()
+	if len(emitter) != 0 {
+		t.Errorf("EmitMetricUpdate emitted %d events want 0", len(emitter))
+	}
+}
@@ -0,0 +1,304 @@
+// Copyright 2021 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nfs4
+
+import (
+	"fmt"
+	"strconv"
+)
+
+// File types (nfs_ftype4).
+const (
+	TypeRegular   = 1
+	TypeDirectory = 2
+	TypeBlock     = 3
+	TypeChar      = 4
+	TypeSymlink   = 5
+	TypeSocket    = 6
+	TypeFIFO      = 7
+)
+
+// Attribute numbers, from RFC 5661, section 5.
+const (
+	attrType          = 1
+	attrChange        = 3
+	attrSize          = 4
+	attrFSID          = 8
+	attrFileID        = 20
+	attrFilesAvail    = 21
+	attrFilesFree     = 22
+	attrFilesTotal    = 23
+	attrMaxName       = 29
+	attrMode          = 33
+	attrNumLinks      = 35
+	attrOwner         = 36
+	attrOwnerGroup    = 37
+	attrRawDev        = 41
+	attrSpaceAvail    = 42
+	attrSpaceFree     = 43
+	attrSpaceTotal    = 44
+	attrSpaceUsed     = 45
+	attrTimeAccess    = 47
+	attrTimeAccessSet = 48
+	attrTimeMetadata  = 52
+	attrTimeModify    = 53
+	attrTimeModifySet = 54
+)
+
+// nobody is the ID used for owners that can't be mapped to a numeric ID.
+const nobody = 65534
+
+// bitmap is an attribute bitmap (bitmap4).
+type bitmap [2]uint32
+
+// makeBitmap returns a bitmap with the given attributes set.
+func makeBitmap(attrs ...uint32) bitmap {
+	var bm bitmap
+	for _, a := range attrs {
+		bm[a/32] |= 1 << (a % 32)
+	}
+	return bm
+}
+
+// encode encodes bm.
+func (bm bitmap) encode(e *encoder) {
+	e.uint32(uint32(len(bm)))
+	for _, w := range bm {
+		e.uint32(w)
+	}
+}
+
+// fileAttrs are the attributes requested for files.
+var fileAttrs = makeBitmap(attrType, attrChange, attrSize, attrFSID, attrFileID, attrMode, attrNumLinks, attrOwner, attrOwnerGroup, attrRawDev, attrSpaceUsed, attrTimeAccess, attrTimeMetadata, attrTimeModify)
+
+// statFSAttrs are the attributes requested for filesystem statistics.
+var statFSAttrs = makeBitmap(attrFilesAvail, attrFilesFree, attrFilesTotal, attrMaxName, attrSpaceAvail, attrSpaceFree, attrSpaceTotal)
+
+// direntAttrs are the attributes requested for directory entries.
+var direntAttrs = makeBitmap(attrType, attrFileID)
+
+// Time is an NFS timestamp (nfstime4).
+type Time struct {
+	Sec  int64
+	Nsec uint32
+}
+
+// Attr holds the attributes of a file.
+type Attr struct {
+	Type      uint32
+	Change    uint64
+	Size      uint64
+	FSIDMajor uint64
+	FSIDMinor uint64
+	FileID    uint64
+	Mode      uint32
+	NumLinks  uint32
+	UID       uint32
+	GID       uint32
+	RdevMajor uint32
+	RdevMinor uint32
+	SpaceUsed uint64
+	Atime     Time
+	Ctime     Time
+	Mtime     Time
+}
+
+// FSStat holds filesystem statistics.
+type FSStat struct {
+	FilesAvail uint64
+	FilesFree  uint64
+	FilesTotal uint64
+	MaxName    uint32
+	SpaceAvail uint64
+	SpaceFree  uint64
+	SpaceTotal uint64
+}
+
+// attrDecoder decodes the value of a single attribute.
+type attrDecoder func(d *decoder, attr uint32) error
+
+// decodeFattr decodes a fattr4, calling fn for each attribute that is present,
+// in order. fn must consume the attribute's value.
+func decodeFattr(d *decoder, fn attrDecoder) error {
+	bm := d.bitmap()
+	vals := decoder{buf: d.opaque()}
+	if d.err != nil {
+		return d.err
+	}
+	for i, w := range bm {
+		for bit := uint32(0); bit < 32; bit++ {
+			if w&(1<<bit) == 0 {
+				continue
+			}
+			if err := fn(&vals, uint32(i)*32+bit); err != nil {
+				return err
+			}
+		}
+	}
+	return vals.err
+}
+
+// decodeAttr decodes a fattr4 holding file attributes.
+func decodeAttr(d *decoder) (Attr, error) {
+	attr := Attr{UID: nobody, GID: nobody}
+	err := decodeFattr(d, func(d *decoder, a uint32) error {
+		switch a {
+		case attrType:
+			attr.Type = d.uint32()
+		case attrChange:
+			attr.Change = d.uint64()
+		case attrSize:
+			attr.Size = d.uint64()
+		case attrFSID:
+			attr.FSIDMajor = d.uint64()
+			attr.FSIDMinor = d.uint64()
+		case attrFileID:
+			attr.FileID = d.uint64()
+		case attrMode:
+			attr.Mode = d.uint32()
+		case attrNumLinks:
+			attr.NumLinks = d.uint32()
+		case attrOwner:
+			attr.UID = parseOwner(d.string())
+		case attrOwnerGroup:
+			attr.GID = parseOwner(d.string())
+		case attrRawDev:
+			attr.RdevMajor = d.uint32()
+			attr.RdevMinor = d.uint32()
+		case attrSpaceUsed:
+			attr.SpaceUsed = d.uint64()
+		case attrTimeAccess:
+			attr.Atime = decodeTime(d)
+		case attrTimeMetadata:
+			attr.Ctime = decodeTime(d)
+		case attrTimeModify:
+			attr.Mtime = decodeTime(d)
+		default:
+			return fmt.Errorf("unexpected attribute %d", a)
+		}
+		return nil
+	})
+	return attr, err
+}
+
+// decodeFSStat decodes a fattr4 holding filesystem statistics.
+func decodeFSStat(d *decoder) (FSStat, error) {
+	var st FSStat
+	err := decodeFattr(d, func(d *decoder, a uint32) error {
+		switch a {
+		case attrFilesAvail:
+			st.FilesAvail = d.uint64()
+		case attrFilesFree:
+			st.FilesFree = d.uint64()
+		case attrFilesTotal:
+			st.FilesTotal = d.uint64()
+		case attrMaxName:
+			st.MaxName = d.uint32()
+		case attrSpaceAvail:
+			st.SpaceAvail = d.uint64()
+		case attrSpaceFree:
+			st.SpaceFree = d.uint64()
+		case attrSpaceTotal:
+			st.SpaceTotal = d.uint64()
+		default:
+			return fmt.Errorf("unexpected attribute %d", a)
+		}
+		return nil
+	})
+	return st, err
+}
+
+// decodeTime decodes a nfstime4.
+func decodeTime(d *decoder) Time {
+	return Time{Sec: int64(d.uint64()), Nsec: d.uint32()}
+}
+
+// parseOwner returns the ID for an owner or owner_group attribute. Only
+// numeric IDs are supported, as sent by servers that don't map IDs to names
+// for AUTH_SYS clients; other owners are mapped to nobody.
+func parseOwner(s string) uint32 {
+	id, err := strconv.ParseUint(s, 10, 32)
+	if err != nil {
+		return nobody
+	}
+	return uint32(id)
+}
+
+// SetAttr describes attributes to set. Only attributes whose Set* field is
+// true are set.
+type SetAttr struct {
+	SetMode  bool
+	SetUID   bool
+	SetGID   bool
+	SetSize  bool
+	SetAtime bool
+	SetMtime bool
+
+	// AtimeNow and MtimeNow set the corresponding timestamps to the
+	// server's current time.
+	AtimeNow bool
+	MtimeNow bool
+
+	Mode  uint32
+	UID   uint32
+	GID   uint32
+	Size  uint64
+	Atime Time
+	Mtime Time
+}
+
+// encode encodes sa as a fattr4.
+func (sa *SetAttr) encode(e *encoder) {
+	var attrs []uint32
+	var vals encoder
+	// Attributes must be encoded in order of their numbers.
+	if sa.SetSize {
+		attrs = append(attrs, attrSize)
+		vals.uint64(sa.Size)
+	}
+	if sa.SetMode {
+		attrs = append(attrs, attrMode)
+		vals.uint32(sa.Mode & 07777)
+	}
+	if sa.SetUID {
+		attrs = append(attrs, attrOwner)
+		vals.string(strconv.FormatUint(uint64(sa.UID), 10))
+	}
+	if sa.SetGID {
+		attrs = append(attrs, attrOwnerGroup)
+		vals.string(strconv.FormatUint(uint64(sa.GID), 10))
+	}
+	if sa.SetAtime {
+		attrs = append(attrs, attrTimeAccessSet)
+		encodeSetTime(&vals, sa.AtimeNow, sa.Atime)
+	}
+	if sa.SetMtime {
+		attrs = append(attrs, attrTimeModifySet)
+		encodeSetTime(&vals, sa.MtimeNow, sa.Mtime)
+	}
+	makeBitmap(attrs...).encode(e)
+	e.opaque(vals.buf)
+}
+
+// encodeSetTime encodes a settime4.
+func encodeSetTime(e *encoder, now bool, t Time) {
+	if now {
+		e.uint32(0) // SET_TO_SERVER_TIME4
+		return
+	}
+	e.uint32(1) // SET_TO_CLIENT_TIME4
+	e.uint64(uint64(t.Sec))
+	e.uint32(t.Nsec)
+}
@@ -0,0 +1,569 @@
+// Copyright 2021 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nfs4
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"sort"
+	"syscall"
+	"testing"
+
+	"gvisor.dev/gvisor/pkg/sync"
+)
+
+// fakeFile is a file served by fakeServer.
+type fakeFile struct {
+	attr     Attr
+	data     []byte
+	children map[string]string
+}
+
+// fakeServer is a minimal in-memory NFSv4.1 server.
+type fakeServer struct {
+	t  *testing.T
+	fd int
+
+	// done is closed when serve returns.
+	done chan struct{}
+
+	mu sync.Mutex
+
+	// files maps file handles to files.
+	files map[string]*fakeFile
+
+	// slotSeqs are the expected sequence IDs of each slot.
+	slotSeqs map[uint32]uint32
+
+	// delays is the number of requests to fail with NFS4ERR_DELAY.
+	delays int
+
+	// uids are the UIDs of the credentials of received requests.
+	uids []uint32
+
+	// maxDirents is the maximum number of entries returned by READDIR.
+	maxDirents int
+}
+
+// newFakeServer returns a connected client and fake server, whose root
+// directory contains files.
+func newFakeServer(t *testing.T, files map[string]string) (*Client, *fakeServer) {
+	fds, err := syscall.Socketpair(syscall.AF_UNIX, syscall.SOCK_STREAM, 0)
+	if err != nil {
+		t.Fatalf("Socketpair failed: %v", err)
+	}
+	s := &fakeServer{
+		t:          t,
+		fd:         fds[1],
+		done:       make(chan struct{}),
+		files:      map[string]*fakeFile{},
+		slotSeqs:   map[uint32]uint32{},
+		maxDirents: 1 << 30,
+	}
+	root := &fakeFile{
+		attr:     Attr{Type: TypeDirectory, FileID: 1, Mode: 0755, NumLinks: 2},
+		children: map[string]string{},
+	}
+	s.files["root"] = root
+	id := uint64(2)
+	for name, data := range files {
+		fh := fmt.Sprintf("fh-%s", name)
+		s.files[fh] = &fakeFile{
+			attr: Attr{Type: TypeRegular, FileID: id, Mode: 0644, NumLinks: 1, UID: 1000, GID: 1000, Size: uint64(len(data))},
+			data: []byte(data),
+		}
+		root.children[name] = fh
+		id++
+	}
+	go s.serve()
+	t.Cleanup(func() {
+		// Wait for serve to return before closing the socket, so that it
+		// doesn't read from a reused file descriptor.
+		syscall.Shutdown(s.fd, syscall.SHUT_RDWR)
+		<-s.done
+		syscall.Close(s.fd)
+	})
+
+	c, err := NewClient(fds[0], "test-client")
+	if err != nil {
+		t.Fatalf("NewClient failed: %v", err)
+	}
+	t.Cleanup(c.Close)
+	return c, s
+}
+
+// serve handles calls until the connection is closed.
+func (s *fakeServer) serve() {
+	defer close(s.done)
+	for {
+		rec, err := readRecord(s.fd)
+		if err != nil {
+			return
+		}
+		d := decoder{buf: rec}
+		xid := d.uint32()
+		d.uint32() // msg_type
+		d.uint32() // rpcvers
+		d.uint32() // prog
+		d.uint32() // vers
+		d.uint32() // proc
+		d.uint32() // cred flavor
+		cred := decoder{buf: d.opaque()}
+		d.uint32()    // verf flavor
+		d.opaque()    // verf body
+		cred.uint32() // stamp
+		cred.string() // machinename
+		uid := cred.uint32()
+
+		var e encoder
+		e.uint32(0)
+		e.uint32(xid)
+		e.uint32(msgReply)
+		e.uint32(replyAccepted)
+		e.uint32(authNone)
+		e.uint32(0)
+		e.uint32(acceptSuccess)
+		s.mu.Lock()
+		s.uids = append(s.uids, uid)
+		s.compound(&d, &e)
+		s.mu.Unlock()
+		binary.BigEndian.PutUint32(e.buf, lastFragment|uint32(len(e.buf)-4))
+		if err := writeFull(s.fd, e.buf); err != nil {
+			return
+		}
+	}
+}
+
+// compound executes the COMPOUND with arguments decoded from d, and encodes
+// its results to e.
+//
+// Preconditions: s.mu must be locked.
+func (s *fakeServer) compound(d *decoder, e *encoder) {
+	d.string() // tag
+	d.uint32() // minorversion
+	nops := d.uint32()
+	var (
+		results encoder
+		n       uint32
+		status  uint32
+		cur     string
+	)
+	for i := uint32(0); i < nops && status == statusOK; i++ {
+		op := d.uint32()
+		var res encoder
+		status, cur = s.op(op, d, &res, cur, i)
+		results.uint32(op)
+		results.uint32(status)
+		if status == statusOK {
+			results.buf = append(results.buf, res.buf...)
+		}
+		n++
+	}
+	if d.err != nil {
+		s.t.Errorf("decoding COMPOUND arguments: %v", d.err)
+	}
+	e.uint32(status)
+	e.string("")
+	e.uint32(n)
+	e.buf = append(e.buf, results.buf...)
+}
+
+// op executes a single operation, and returns its status and the new current
+// file handle.
+//
+// Preconditions: s.mu must be locked.
+func (s *fakeServer) op(op uint32, d *decoder, e *encoder, cur string, index uint32) (uint32, string) {
+	switch op {
+	case opExchangeID:
+		d.uint64()   // verifier
+		d.string()   // ownerid
+		d.uint32()   // flags
+		d.uint32()   // state protect
+		d.uint32()   // impl id
+		e.uint64(42) // clientid
+		e.uint32(1)  // sequenceid
+		e.uint32(0)  // flags
+		e.uint32(0)  // state protect
+		e.uint64(0)  // server owner minor id
+		e.string("fake")
+		e.string("fake") // scope
+		e.uint32(0)      // impl id
+	case opCreateSession:
+		d.uint64() // clientid
+		d.uint32() // sequence
+		d.uint32() // flags
+		decodeChannelAttrs(d)
+		decodeChannelAttrs(d)
+		d.uint32() // cb_program
+		for n := d.uint32(); n > 0; n-- {
+			d.uint32()
+		}
+		e.fixed(make([]byte, sessionIDSize))
+		e.uint32(1) // sequence
+		e.uint32(0) // flags
+		encodeChannelAttrs(e, 64<<10, 4)
+		encodeChannelAttrs(e, 4096, 1)
+	case opSequence:
+		if index != 0 {
+			return statusInval, cur
+		}
+		d.fixed(sessionIDSize)
+		seq := d.uint32()
+		slotID := d.uint32()
+		d.uint32() // highest slotid
+		d.bool()   // cachethis
+		if s.delays > 0 {
+			s.delays--
+			return statusDelay, cur
+		}
+		want, ok := s.slotSeqs[slotID]
+		if !ok {
+			want = 1
+		}
+		if seq != want {
+			s.t.Errorf("slot %d: got sequence ID %d, want %d", slotID, seq, want)
+			return 10063 /* NFS4ERR_SEQ_MISORDERED */, cur
+		}
+		s.slotSeqs[slotID] = seq + 1
+		e.fixed(make([]byte, sessionIDSize))
+		e.uint32(seq)
+		e.uint32(slotID)
+		e.uint32(3)
+		e.uint32(3)
+		e.uint32(0)
+	case opReclaimComplete:
+		d.bool()
+	case opDestroySession:
+		d.fixed(sessionIDSize)
+	case opDestroyClientID:
+		d.uint64()
+	case opPutRootFH:
+		cur = "root"
+	case opPutFH:
+		cur = string(d.opaque())
+		if s.files[cur] == nil {
+			return statusStale, cur
+		}
+	case opGetFH:
+		e.opaque([]byte(cur))
+	case opLookup:
+		name := d.string()
+		f := s.files[cur]
+		if f.attr.Type != TypeDirectory {
+			return statusNotDir, cur
+		}
+		fh, ok := f.children[name]
+		if !ok {
+			return statusNoEnt, cur
+		}
+		cur = fh
+	case opGetAttr:
+		d.bitmap()
+		encodeFakeAttr(e, s.files[cur].attr)
+	case opOpen:
+		d.uint32() // seqid
+		d.uint32() // access
+		d.uint32() // deny
+		d.uint64() // clientid
+		d.opaque() // owner
+		if openType := d.uint32(); openType != openNoCreate {
+			return statusNotSupp, cur
+		}
+		if claim := d.uint32(); claim != claimFH {
+			return statusNotSupp, cur
+		}
+		(&StateID{Seq: 1, Other: [12]byte{1}}).encode(e)
+		e.bool(true)
+		e.uint64(0)
+		e.uint64(0)
+		e.uint32(0) // rflags
+		e.uint32(0) // attrset
+		e.uint32(delegNoneExt)
+		e.uint32(whyNoDelegResource)
+		e.bool(false)
+	case opClose:
+		d.uint32()
+		decodeStateID(d)
+		(&StateID{}).encode(e)
+	case opRead:
+		decodeStateID(d)
+		off := d.uint64()
+		count := d.uint32()
+		data := s.files[cur].data
+		if off > uint64(len(data)) {
+			off = uint64(len(data))
+		}
+		data = data[off:]
+		if uint64(len(data)) > uint64(count) {
+			data = data[:count]
+		}
+		e.bool(off+uint64(len(data)) == uint64(len(s.files[cur].data)))
+		e.opaque(data)
+	case opWrite:
+		decodeStateID(d)
+		off := d.uint64()
+		d.uint32() // stable
+		data := d.opaque()
+		f := s.files[cur]
+		if end := off + uint64(len(data)); end > uint64(len(f.data)) {
+			f.data = append(f.data, make([]byte, end-uint64(len(f.data)))...)
+			f.attr.Size = end
+		}
+		copy(f.data[off:], data)
+		e.uint32(uint32(len(data)))
+		e.uint32(fileSync)
+		e.uint64(0) // verifier
+	case opReadDir:
+		cookie := d.uint64()
+		d.fixed(8)
+		d.uint32()
+		d.uint32()
+		d.bitmap()
+		f := s.files[cur]
+		var names []string
+		for name := range f.children {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		e.uint64(0) // verifier
+		i := int(cookie)
+		for ; i < len(names) && i < int(cookie)+s.maxDirents; i++ {
+			e.bool(true)
+			e.uint64(uint64(i + 1))
+			e.string(names[i])
+			child := s.files[f.children[names[i]]]
+			direntAttrs.encode(e)
+			var vals encoder
+			vals.uint32(child.attr.Type)
+			vals.uint64(child.attr.FileID)
+			e.opaque(vals.buf)
+		}
+		e.bool(false)
+		e.bool(i == len(names))
+	case opRemove:
+		name := d.string()
+		f := s.files[cur]
+		if _, ok := f.children[name]; !ok {
+			return statusNoEnt, cur
+		}
+		delete(f.children, name)
+		e.bool(true)
+		e.uint64(0)
+		e.uint64(0)
+	default:
+		s.t.Errorf("unexpected operation %d", op)
+		return statusNotSupp, cur
+	}
+	return statusOK, cur
+}
+
+// encodeFakeAttr encodes attr as a fattr4 holding fileAttrs.
+func encodeFakeAttr(e *encoder, attr Attr) {
+	fileAttrs.encode(e)
+	var vals encoder
+	vals.uint32(attr.Type)
+	vals.uint64(attr.Change)
+	vals.uint64(attr.Size)
+	vals.uint64(attr.FSIDMajor)
+	vals.uint64(attr.FSIDMinor)
+	vals.uint64(attr.FileID)
+	vals.uint32(attr.Mode)
+	vals.uint32(attr.NumLinks)
+	vals.string(fmt.Sprintf("%d", attr.UID))
+	vals.string(fmt.Sprintf("%d", attr.GID))
+	vals.uint32(attr.RdevMajor)
+	vals.uint32(attr.RdevMinor)
+	vals.uint64(attr.SpaceUsed)
+	for _, t := range []Time{attr.Atime, attr.Ctime, attr.Mtime} {
+		vals.uint64(uint64(t.Sec))
+		vals.uint32(t.Nsec)
+	}
+	e.opaque(vals.buf)
+}
+
+func TestXDR(t *testing.T) {
+	var e encoder
+	e.uint32(1)
+	e.uint64(2)
+	e.bool(true)
+	e.string("abcde")
+	e.opaque([]byte{1, 2, 3, 4})
+	if len(e.buf)%4 != 0 {
+		t.Fatalf("encoded %d bytes, want a multiple of 4", len(e.buf))
+	}
+
+	d := decoder{buf: e.buf}
+	if got := d.uint32(); got != 1 {
+		t.Errorf("uint32: got %d, want 1", got)
+	}
+	if got := d.uint64(); got != 2 {
+		t.Errorf("uint64: got %d, want 2", got)
+	}
+	if got := d.bool(); !got {
+		t.Errorf("bool: got false, want true")
+	}
+	if got := d.string(); got != "abcde" {
+		t.Errorf("string: got %q, want abcde", got)
+	}
+	if got := d.opaque(); !bytes.Equal(got, []byte{1, 2, 3, 4}) {
+		t.Errorf("opaque: got %v, want [1 2 3 4]", got)
+	}
+	if d.err != nil || len(d.buf) != 0 {
+		t.Errorf("got error %v and %d bytes left, want no error and 0 bytes", d.err, len(d.buf))
+	}
+	if d.uint32(); d.err != errShortBuffer {
+		t.Errorf("decoding past the end: got error %v, want %v", d.err, errShortBuffer)
+	}
+}
+
+func TestSetAttrEncode(t *testing.T) {
+	sa := SetAttr{SetMode: true, Mode: 0100644, SetUID: true, UID: 123, SetMtime: true, MtimeNow: true}
+	var e encoder
+	sa.encode(&e)
+	d := decoder{buf: e.buf}
+	bm := d.bitmap()
+	want := makeBitmap(attrMode, attrOwner, attrTimeModifySet)
+	if len(bm) != 2 || bm[0] != want[0] || bm[1] != want[1] {
+		t.Fatalf("got bitmap %v, want %v", bm, want)
+	}
+	vals := decoder{buf: d.opaque()}
+	if mode := vals.uint32(); mode != 0644 {
+		t.Errorf("got mode %#o, want 0644", mode)
+	}
+	if owner := vals.string(); owner != "123" {
+		t.Errorf("got owner %q, want 123", owner)
+	}
+	if how := vals.uint32(); how != 0 {
+		t.Errorf("got set_it %d, want SET_TO_SERVER_TIME4", how)
+	}
+	if vals.err != nil || len(vals.buf) != 0 {
+		t.Errorf("got error %v and %d bytes left, want no error and 0 bytes", vals.err, len(vals.buf))
+	}
+}
+
+func TestLookup(t *testing.T) {
+	c, s := newFakeServer(t, map[string]string{"foo": "hello"})
+	creds := &Credentials{UID: 1000, GID: 1000}
+
+	root, attr, err := c.Root(creds, nil)
+	if err != nil {
+		t.Fatalf("Root failed: %v", err)
+	}
+	if attr.Type != TypeDirectory || attr.FileID != 1 {
+		t.Errorf("got root attributes %+v, want directory with file ID 1", attr)
+	}
+
+	fh, attr, err := c.Lookup(creds, root, "foo")
+	if err != nil {
+		t.Fatalf("Lookup failed: %v", err)
+	}
+	if string(fh) != "fh-foo" {
+		t.Errorf("got file handle %q, want fh-foo", fh)
+	}
+	if attr.Type != TypeRegular || attr.Size != 5 || attr.UID != 1000 || attr.Mode != 0644 {
+		t.Errorf("got attributes %+v, want regular file of size 5 with UID 1000 and mode 0644", attr)
+	}
+
+	if _, _, err := c.Lookup(creds, root, "bar"); err != syscall.ENOENT {
+		t.Errorf("Lookup of missing file: got error %v, want ENOENT", err)
+	}
+	if _, err := c.GetAttr(creds, FileHandle("bad")); err != syscall.ESTALE {
+		t.Errorf("GetAttr of bad file handle: got error %v, want ESTALE", err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if got := s.uids[len(s.uids)-1]; got != 1000 {
+		t.Errorf("got request with UID %d, want 1000", got)
+	}
+}
+
+func TestReadWrite(t *testing.T) {
+	c, _ := newFakeServer(t, map[string]string{"foo": "hello"})
+	creds := &Credentials{}
+
+	fh := FileHandle("fh-foo")
+	stateid, err := c.Open(creds, fh, AccessBoth)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	if n, err := c.Write(creds, fh, stateid, 5, []byte(" world")); err != nil || n != 6 {
+		t.Fatalf("Write: got (%d, %v), want (6, nil)", n, err)
+	}
+	data, eof, err := c.Read(creds, fh, stateid, 0, 100)
+	if err != nil {
+		t.Fatalf("Read failed: %v", err)
+	}
+	if string(data) != "hello world" || !eof {
+		t.Errorf("Read: got (%q, %t), want (\"hello world\", true)", data, eof)
+	}
+	data, eof, err = c.Read(creds, fh, stateid, 6, 2)
+	if err != nil {
+		t.Fatalf("Read failed: %v", err)
+	}
+	if string(data) != "wo" || eof {
+		t.Errorf("Read: got (%q, %t), want (\"wo\", false)", data, eof)
+	}
+	if err := c.CloseState(creds, fh, stateid); err != nil {
+		t.Errorf("CloseState failed: %v", err)
+	}
+}
+
+func TestReadDir(t *testing.T) {
+	c, s := newFakeServer(t, map[string]string{"a": "", "b": "", "c": ""})
+	s.mu.Lock()
+	s.maxDirents = 2
+	s.mu.Unlock()
+
+	dirents, err := c.ReadDir(&Credentials{}, FileHandle("root"))
+	if err != nil {
+		t.Fatalf("ReadDir failed: %v", err)
+	}
+	var names []string
+	for _, dirent := range dirents {
+		if dirent.Type != TypeRegular || dirent.FileID < 2 {
+			t.Errorf("got entry %+v, want regular file with file ID >= 2", dirent)
+		}
+		names = append(names, dirent.Name)
+	}
+	if got, want := fmt.Sprint(names), "[a b c]"; got != want {
+		t.Errorf("got entries %s, want %s", got, want)
+	}
+}
+
+func TestRetryDelay(t *testing.T) {
+	c, s := newFakeServer(t, nil)
+	s.mu.Lock()
+	s.delays = 2
+	s.mu.Unlock()
+
+	// The sequence IDs of the slots used by the delayed requests must not
+	// advance; fakeServer checks them.
+	if err := c.Remove(&Credentials{}, FileHandle("root"), "foo"); err != syscall.ENOENT {
+		t.Errorf("Remove: got error %v, want ENOENT", err)
+	}
+	if _, err := c.GetAttr(&Credentials{}, FileHandle("root")); err != nil {
+		t.Errorf("GetAttr failed: %v", err)
+	}
+}
+
+func TestConnectionFailure(t *testing.T) {
+	c, s := newFakeServer(t, nil)
+	syscall.Shutdown(s.fd, syscall.SHUT_RDWR)
+	if _, err := c.GetAttr(&Credentials{}, FileHandle("root")); err != syscall.EIO {
+		t.Errorf("GetAttr after connection failure: got error %v, want EIO", err)
+	}
+}
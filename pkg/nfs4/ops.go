@@ -0,0 +1,629 @@
+// Copyright 2021 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nfs4
+
+import (
+	"fmt"
+	"sync/atomic"
+	"syscall"
+
+	"gvisor.dev/gvisor/pkg/log"
+)
+
+// Open share access modes.
+const (
+	AccessRead  = 1
+	AccessWrite = 2
+	AccessBoth  = AccessRead | AccessWrite
+
+	// wantNoDeleg asks the server not to grant a delegation.
+	wantNoDeleg = 0x0400
+)
+
+// Constants used in OPEN arguments and results.
+const (
+	openNoCreate = 0
+	openCreate   = 1
+
+	createGuarded = 1
+
+	claimNull = 0
+	claimFH   = 4
+
+	delegNone    = 0
+	delegRead    = 1
+	delegWrite   = 2
+	delegNoneExt = 3
+
+	// whyNoDelegContention and whyNoDelegResource are the reasons for not
+	// granting a delegation that are followed by a boolean.
+	whyNoDelegContention = 7
+	whyNoDelegResource   = 8
+
+	// fileSync is the FILE_SYNC4 stable_how.
+	fileSync = 2
+)
+
+// FileHandle is an opaque file handle.
+type FileHandle []byte
+
+// StateID is an open stateid (stateid4).
+type StateID struct {
+	Seq   uint32
+	Other [12]byte
+}
+
+// currentStateID refers to the stateid returned by the previous operation in
+// the same COMPOUND (RFC 5661, section 16.2.3.1.2).
+var currentStateID = StateID{Seq: 1}
+
+// anonymousStateID is used for operations without an open file.
+var anonymousStateID = StateID{}
+
+// encode encodes s.
+func (s *StateID) encode(e *encoder) {
+	e.uint32(s.Seq)
+	e.fixed(s.Other[:])
+}
+
+// decodeStateID decodes a stateid4.
+func decodeStateID(d *decoder) StateID {
+	var s StateID
+	s.Seq = d.uint32()
+	copy(s.Other[:], d.fixed(uint32(len(s.Other))))
+	return s
+}
+
+// Dirent is a directory entry.
+type Dirent struct {
+	Name   string
+	Type   uint32
+	FileID uint64
+}
+
+// putFH appends a PUTFH operation for fh to b.
+func (b *compound) putFH(fh FileHandle) {
+	b.op(opPutFH).opaque(fh)
+}
+
+// getAttr appends a GETATTR operation for file attributes to b.
+func (b *compound) getAttr() {
+	fileAttrs.encode(b.op(opGetAttr))
+}
+
+// decodeGetFHAttr decodes the results of GETFH and GETATTR operations.
+func (r *result) decodeGetFHAttr() (FileHandle, Attr, error) {
+	if err := r.next(opGetFH); err != nil {
+		return nil, Attr{}, err
+	}
+	fh := FileHandle(append([]byte(nil), r.d.opaque()...))
+	attr, err := r.decodeGetAttr()
+	return fh, attr, err
+}
+
+// decodeGetAttr decodes the results of a GETATTR operation.
+func (r *result) decodeGetAttr() (Attr, error) {
+	if err := r.next(opGetAttr); err != nil {
+		return Attr{}, err
+	}
+	attr, err := decodeAttr(&r.d)
+	if err != nil {
+		log.Warningf("nfs4: decoding attributes: %v", err)
+		return Attr{}, syscall.EIO
+	}
+	return attr, r.done()
+}
+
+// skipChangeInfo consumes a change_info4.
+func (r *result) skipChangeInfo() {
+	r.d.bool()   // atomic
+	r.d.uint64() // before
+	r.d.uint64() // after
+}
+
+// Root returns the file handle and attributes of the file at path, relative
+// to the server's root.
+func (c *Client) Root(creds *Credentials, path []string) (FileHandle, Attr, error) {
+	var b compound
+	b.op(opPutRootFH)
+	for _, name := range path {
+		b.op(opLookup).string(name)
+	}
+	b.op(opGetFH)
+	b.getAttr()
+	r, err := c.call(creds, &b)
+	if err != nil {
+		return nil, Attr{}, err
+	}
+	if err := r.next(opPutRootFH); err != nil {
+		return nil, Attr{}, err
+	}
+	for range path {
+		if err := r.next(opLookup); err != nil {
+			return nil, Attr{}, err
+		}
+	}
+	return r.decodeGetFHAttr()
+}
+
+// Lookup returns the file handle and attributes of the file with the given
+// name in directory dir.
+func (c *Client) Lookup(creds *Credentials, dir FileHandle, name string) (FileHandle, Attr, error) {
+	var b compound
+	b.putFH(dir)
+	b.op(opLookup).string(name)
+	b.op(opGetFH)
+	b.getAttr()
+	r, err := c.call(creds, &b)
+	if err != nil {
+		return nil, Attr{}, err
+	}
+	if err := r.next(opPutFH); err != nil {
+		return nil, Attr{}, err
+	}
+	if err := r.next(opLookup); err != nil {
+		return nil, Attr{}, err
+	}
+	return r.decodeGetFHAttr()
+}
+
+// GetAttr returns the attributes of a file.
+func (c *Client) GetAttr(creds *Credentials, fh FileHandle) (Attr, error) {
+	var b compound
+	b.putFH(fh)
+	b.getAttr()
+	r, err := c.call(creds, &b)
+	if err != nil {
+		return Attr{}, err
+	}
+	if err := r.next(opPutFH); err != nil {
+		return Attr{}, err
+	}
+	return r.decodeGetAttr()
+}
+
+// SetAttr sets the attributes of a file and returns its updated attributes.
+// If the file's size is set, stateid may be an open stateid for the file
+// permitting writes; otherwise, it should be nil.
+func (c *Client) SetAttr(creds *Credentials, fh FileHandle, stateid *StateID, sa *SetAttr) (Attr, error) {
+	if stateid == nil {
+		stateid = &anonymousStateID
+	}
+	var b compound
+	b.putFH(fh)
+	e := b.op(opSetAttr)
+	stateid.encode(e)
+	sa.encode(e)
+	b.getAttr()
+	r, err := c.call(creds, &b)
+	if err != nil {
+		return Attr{}, err
+	}
+	if err := r.next(opPutFH); err != nil {
+		return Attr{}, err
+	}
+	if err := r.next(opSetAttr); err != nil {
+		return Attr{}, err
+	}
+	r.d.bitmap() // attrsset
+	return r.decodeGetAttr()
+}
+
+// openOwner returns a new open-owner. Each OPEN uses a distinct open-owner,
+// so that each open file has its own stateid that is unaffected by other
+// OPENs and CLOSEs of the same file.
+func (c *Client) openOwner(e *encoder) {
+	e.uint64(c.clientID)
+	e.string(fmt.Sprintf("open-%d", atomic.AddUint64(&c.openOwners, 1)))
+}
+
+// decodeOpen decodes the results of an OPEN operation.
+func (r *result) decodeOpen() (StateID, error) {
+	if err := r.next(opOpen); err != nil {
+		return StateID{}, err
+	}
+	stateid := decodeStateID(&r.d)
+	r.skipChangeInfo()
+	r.d.uint32() // rflags
+	r.d.bitmap() // attrset
+	// Delegations aren't requested, and can't be recalled without a back
+	// channel, so servers don't grant them. Skip any that are granted
+	// anyway.
+	switch deleg := r.d.uint32(); deleg {
+	case delegNone:
+	case delegRead, delegWrite:
+		decodeStateID(&r.d)
+		r.d.bool() // recall
+		if deleg == delegWrite {
+			if limitBy := r.d.uint32(); limitBy == 1 {
+				r.d.uint64() // filesize
+			} else {
+				r.d.uint32() // num_blocks
+				r.d.uint32() // bytes_per_block
+			}
+		}
+		r.d.uint32() // nfsace4 type
+		r.d.uint32() // flag
+		r.d.uint32() // access_mask
+		r.d.opaque() // who
+		log.Infof("nfs4: ignoring unrequested delegation of type %d", deleg)
+	case delegNoneExt:
+		if why := r.d.uint32(); why == whyNoDelegContention || why == whyNoDelegResource {
+			r.d.bool()
+		}
+	default:
+		log.Warningf("nfs4: invalid delegation type %d", deleg)
+		return StateID{}, syscall.EIO
+	}
+	return stateid, r.done()
+}
+
+// Open opens a file with the given share access (AccessRead, AccessWrite or
+// AccessBoth). The returned stateid must be closed with CloseState.
+func (c *Client) Open(creds *Credentials, fh FileHandle, access uint32) (StateID, error) {
+	var b compound
+	b.putFH(fh)
+	e := b.op(opOpen)
+	e.uint32(0) // seqid
+	e.uint32(access | wantNoDeleg)
+	e.uint32(0) // share_deny
+	c.openOwner(e)
+	e.uint32(openNoCreate)
+	e.uint32(claimFH)
+	r, err := c.call(creds, &b)
+	if err != nil {
+		return StateID{}, err
+	}
+	if err := r.next(opPutFH); err != nil {
+		return StateID{}, err
+	}
+	return r.decodeOpen()
+}
+
+// CreateFile creates a regular file with the given name and mode in directory
+// dir, failing with EEXIST if it already exists, and returns the new file's
+// file handle and attributes. The file is not left open.
+func (c *Client) CreateFile(creds *Credentials, dir FileHandle, name string, mode uint32) (FileHandle, Attr, error) {
+	var b compound
+	b.putFH(dir)
+	e := b.op(opOpen)
+	e.uint32(0) // seqid
+	e.uint32(AccessRead | wantNoDeleg)
+	e.uint32(0) // share_deny
+	c.openOwner(e)
+	e.uint32(openCreate)
+	e.uint32(createGuarded)
+	(&SetAttr{SetMode: true, Mode: mode}).encode(e)
+	e.uint32(claimNull)
+	e.string(name)
+	b.op(opGetFH)
+	b.getAttr()
+	e = b.op(opClose)
+	e.uint32(0) // seqid
+	currentStateID.encode(e)
+	r, err := c.call(creds, &b)
+	if err != nil {
+		return nil, Attr{}, err
+	}
+	if err := r.next(opPutFH); err != nil {
+		return nil, Attr{}, err
+	}
+	if _, err := r.decodeOpen(); err != nil {
+		return nil, Attr{}, err
+	}
+	fh, attr, err := r.decodeGetFHAttr()
+	if err != nil {
+		return nil, Attr{}, err
+	}
+	if err := r.next(opClose); err != nil {
+		// The file was created, and the server releases its open state
+		// when the session is destroyed.
+		log.Infof("nfs4: CLOSE after create failed: %v", err)
+	}
+	return fh, attr, nil
+}
+
+// CloseState closes a stateid returned by Open.
+func (c *Client) CloseState(creds *Credentials, fh FileHandle, stateid StateID) error {
+	var b compound
+	b.putFH(fh)
+	e := b.op(opClose)
+	e.uint32(0) // seqid
+	stateid.encode(e)
+	r, err := c.call(creds, &b)
+	if err != nil {
+		return err
+	}
+	if err := r.next(opPutFH); err != nil {
+		return err
+	}
+	return r.next(opClose)
+}
+
+// Read reads up to MaxIO() bytes of data at the given offset. It returns the
+// data read, and whether the end of the file was reached.
+func (c *Client) Read(creds *Credentials, fh FileHandle, stateid StateID, off uint64, count uint32) ([]byte, bool, error) {
+	if count > c.maxIO {
+		count = c.maxIO
+	}
+	var b compound
+	b.putFH(fh)
+	e := b.op(opRead)
+	stateid.encode(e)
+	e.uint64(off)
+	e.uint32(count)
+	r, err := c.call(creds, &b)
+	if err != nil {
+		return nil, false, err
+	}
+	if err := r.next(opPutFH); err != nil {
+		return nil, false, err
+	}
+	if err := r.next(opRead); err != nil {
+		return nil, false, err
+	}
+	eof := r.d.bool()
+	data := r.d.opaque()
+	if err := r.done(); err != nil {
+		return nil, false, err
+	}
+	if uint32(len(data)) > count {
+		return nil, false, syscall.EIO
+	}
+	return data, eof, nil
+}
+
+// Write writes up to MaxIO() bytes of data at the given offset, and returns
+// the number of bytes written. Data is written to stable storage before Write
+// returns.
+func (c *Client) Write(creds *Credentials, fh FileHandle, stateid StateID, off uint64, data []byte) (uint32, error) {
+	if uint32(len(data)) > c.maxIO {
+		data = data[:c.maxIO]
+	}
+	var b compound
+	b.putFH(fh)
+	e := b.op(opWrite)
+	stateid.encode(e)
+	e.uint64(off)
+	e.uint32(fileSync)
+	e.opaque(data)
+	r, err := c.call(creds, &b)
+	if err != nil {
+		return 0, err
+	}
+	if err := r.next(opPutFH); err != nil {
+		return 0, err
+	}
+	if err := r.next(opWrite); err != nil {
+		return 0, err
+	}
+	n := r.d.uint32()
+	if err := r.done(); err != nil {
+		return 0, err
+	}
+	if n > uint32(len(data)) {
+		return 0, syscall.EIO
+	}
+	return n, nil
+}
+
+// Create creates a file that isn't a regular file with the given name and
+// mode in directory dir, and returns its file handle and attributes. typ is
+// the file type; link is the target of a symbolic link, and rdevMajor and
+// rdevMinor are the device numbers of a device.
+func (c *Client) Create(creds *Credentials, dir FileHandle, name string, typ uint32, mode uint32, link string, rdevMajor, rdevMinor uint32) (FileHandle, Attr, error) {
+	var b compound
+	b.putFH(dir)
+	e := b.op(opCreate)
+	e.uint32(typ)
+	switch typ {
+	case TypeSymlink:
+		e.string(link)
+	case TypeBlock, TypeChar:
+		e.uint32(rdevMajor)
+		e.uint32(rdevMinor)
+	case TypeDirectory, TypeSocket, TypeFIFO:
+	default:
+		return nil, Attr{}, syscall.EINVAL
+	}
+	e.string(name)
+	(&SetAttr{SetMode: true, Mode: mode}).encode(e)
+	b.op(opGetFH)
+	b.getAttr()
+	r, err := c.call(creds, &b)
+	if err != nil {
+		return nil, Attr{}, err
+	}
+	if err := r.next(opPutFH); err != nil {
+		return nil, Attr{}, err
+	}
+	if err := r.next(opCreate); err != nil {
+		return nil, Attr{}, err
+	}
+	r.skipChangeInfo()
+	r.d.bitmap() // attrset
+	return r.decodeGetFHAttr()
+}
+
+// Remove removes the file with the given name from directory dir.
+func (c *Client) Remove(creds *Credentials, dir FileHandle, name string) error {
+	var b compound
+	b.putFH(dir)
+	b.op(opRemove).string(name)
+	r, err := c.call(creds, &b)
+	if err != nil {
+		return err
+	}
+	if err := r.next(opPutFH); err != nil {
+		return err
+	}
+	return r.next(opRemove)
+}
+
+// Rename renames the file with name oldName in directory oldDir to newName
+// in directory newDir.
+func (c *Client) Rename(creds *Credentials, oldDir FileHandle, oldName string, newDir FileHandle, newName string) error {
+	var b compound
+	b.putFH(oldDir)
+	b.op(opSaveFH)
+	b.putFH(newDir)
+	e := b.op(opRename)
+	e.string(oldName)
+	e.string(newName)
+	r, err := c.call(creds, &b)
+	if err != nil {
+		return err
+	}
+	for _, op := range []uint32{opPutFH, opSaveFH, opPutFH} {
+		if err := r.next(op); err != nil {
+			return err
+		}
+	}
+	return r.next(opRename)
+}
+
+// Link creates a hard link with the given name in directory dir to the file
+// fh, and returns the file's updated attributes.
+func (c *Client) Link(creds *Credentials, fh FileHandle, dir FileHandle, name string) (Attr, error) {
+	var b compound
+	b.putFH(fh)
+	b.op(opSaveFH)
+	b.putFH(dir)
+	b.op(opLink).string(name)
+	b.op(opRestoreFH)
+	b.getAttr()
+	r, err := c.call(creds, &b)
+	if err != nil {
+		return Attr{}, err
+	}
+	for _, op := range []uint32{opPutFH, opSaveFH, opPutFH} {
+		if err := r.next(op); err != nil {
+			return Attr{}, err
+		}
+	}
+	if err := r.next(opLink); err != nil {
+		return Attr{}, err
+	}
+	r.skipChangeInfo()
+	if err := r.next(opRestoreFH); err != nil {
+		return Attr{}, err
+	}
+	return r.decodeGetAttr()
+}
+
+// Readlink returns the target of a symbolic link.
+func (c *Client) Readlink(creds *Credentials, fh FileHandle) (string, error) {
+	var b compound
+	b.putFH(fh)
+	b.op(opReadLink)
+	r, err := c.call(creds, &b)
+	if err != nil {
+		return "", err
+	}
+	if err := r.next(opPutFH); err != nil {
+		return "", err
+	}
+	if err := r.next(opReadLink); err != nil {
+		return "", err
+	}
+	link := r.d.string()
+	return link, r.done()
+}
+
+// ReadDir returns all entries in a directory, excluding "." and "..".
+func (c *Client) ReadDir(creds *Credentials, dir FileHandle) ([]Dirent, error) {
+	var (
+		dirents []Dirent
+		cookie  uint64
+		verf    [8]byte
+	)
+	for {
+		var b compound
+		b.putFH(dir)
+		e := b.op(opReadDir)
+		e.uint64(cookie)
+		e.fixed(verf[:])
+		e.uint32(c.maxIO) // dircount
+		e.uint32(c.maxIO) // maxcount
+		direntAttrs.encode(e)
+		r, err := c.call(creds, &b)
+		if err != nil {
+			return nil, err
+		}
+		if err := r.next(opPutFH); err != nil {
+			return nil, err
+		}
+		if err := r.next(opReadDir); err != nil {
+			return nil, err
+		}
+		copy(verf[:], r.d.fixed(uint32(len(verf))))
+		n := 0
+		for r.d.bool() {
+			cookie = r.d.uint64()
+			var dirent Dirent
+			dirent.Name = r.d.string()
+			if err := decodeFattr(&r.d, func(d *decoder, a uint32) error {
+				switch a {
+				case attrType:
+					dirent.Type = d.uint32()
+				case attrFileID:
+					dirent.FileID = d.uint64()
+				default:
+					return fmt.Errorf("unexpected attribute %d", a)
+				}
+				return nil
+			}); err != nil {
+				log.Warningf("nfs4: decoding directory entry attributes: %v", err)
+				return nil, syscall.EIO
+			}
+			dirents = append(dirents, dirent)
+			n++
+		}
+		eof := r.d.bool()
+		if err := r.done(); err != nil {
+			return nil, err
+		}
+		if eof {
+			return dirents, nil
+		}
+		if n == 0 {
+			// The server made no progress.
+			return nil, syscall.EIO
+		}
+	}
+}
+
+// StatFS returns statistics for the filesystem containing fh.
+func (c *Client) StatFS(creds *Credentials, fh FileHandle) (FSStat, error) {
+	var b compound
+	b.putFH(fh)
+	statFSAttrs.encode(b.op(opGetAttr))
+	r, err := c.call(creds, &b)
+	if err != nil {
+		return FSStat{}, err
+	}
+	if err := r.next(opPutFH); err != nil {
+		return FSStat{}, err
+	}
+	if err := r.next(opGetAttr); err != nil {
+		return FSStat{}, err
+	}
+	st, err := decodeFSStat(&r.d)
+	if err != nil {
+		log.Warningf("nfs4: decoding filesystem attributes: %v", err)
+		return FSStat{}, syscall.EIO
+	}
+	return st, r.done()
+}
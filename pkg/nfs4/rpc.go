@@ -0,0 +1,319 @@
+// Copyright 2021 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nfs4
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"syscall"
+
+	"gvisor.dev/gvisor/pkg/log"
+	"gvisor.dev/gvisor/pkg/sync"
+)
+
+// ONC RPC (RFC 5531) constants.
+const (
+	rpcVersion = 2
+
+	msgCall  = 0
+	msgReply = 1
+
+	replyAccepted = 0
+	replyDenied   = 1
+
+	acceptSuccess = 0
+
+	authNone = 0
+	authSys  = 1
+
+	// lastFragment is set in the record marking header of the last fragment
+	// of a record (RFC 5531, section 11).
+	lastFragment = 0x80000000
+
+	// maxRecordSize bounds the size of received records.
+	maxRecordSize = 4 << 20
+
+	// machineName is sent in AUTH_SYS credentials.
+	machineName = "gvisor"
+
+	// maxGroups is the maximum number of supplementary groups in AUTH_SYS
+	// credentials.
+	maxGroups = 16
+)
+
+// Credentials are the credentials with which a request is sent, as AUTH_SYS
+// credentials.
+type Credentials struct {
+	UID    uint32
+	GID    uint32
+	Groups []uint32
+}
+
+// rootCredentials are used for requests on behalf of the client rather than
+// a user, e.g. to set up a session.
+var rootCredentials = &Credentials{}
+
+// encode encodes c as an opaque_auth.
+func (c *Credentials) encode(e *encoder) {
+	var body encoder
+	body.uint32(0) // stamp
+	body.string(machineName)
+	body.uint32(c.UID)
+	body.uint32(c.GID)
+	groups := c.Groups
+	if len(groups) > maxGroups {
+		groups = groups[:maxGroups]
+	}
+	body.uint32(uint32(len(groups)))
+	for _, g := range groups {
+		body.uint32(g)
+	}
+	e.uint32(authSys)
+	e.opaque(body.buf)
+}
+
+// rpcReply is the result of an RPC.
+type rpcReply struct {
+	// results are the procedure results. results is only valid if err is
+	// nil.
+	results []byte
+	err     error
+}
+
+// rpcConn is an ONC RPC client connection over a stream socket. Calls may be
+// made concurrently; replies are matched to calls by transaction ID.
+type rpcConn struct {
+	// fd is the connected socket. fd is owned by rpcConn.
+	fd int
+
+	// prog and vers identify the RPC program. They are immutable.
+	prog uint32
+	vers uint32
+
+	// writeMu serializes writes to fd.
+	writeMu sync.Mutex
+
+	// mu protects the fields below.
+	mu sync.Mutex
+
+	// xid is the last transaction ID used.
+	xid uint32
+
+	// pending maps the transaction IDs of outstanding calls to the channels
+	// on which their replies are delivered.
+	pending map[uint32]chan rpcReply
+
+	// err is the error that terminated the connection, or nil if the
+	// connection is still usable.
+	err error
+
+	// readerDone is closed when the reader goroutine exits.
+	readerDone chan struct{}
+}
+
+// newRPCConn returns an rpcConn that owns fd, and starts receiving replies.
+func newRPCConn(fd int, prog, vers uint32) *rpcConn {
+	c := &rpcConn{
+		fd:         fd,
+		prog:       prog,
+		vers:       vers,
+		pending:    make(map[uint32]chan rpcReply),
+		readerDone: make(chan struct{}),
+	}
+	go c.readReplies() // S/R-SAFE: nfs4 connections are not saved.
+	return c
+}
+
+// close shuts the connection down, fails all outstanding calls, and closes
+// fd.
+func (c *rpcConn) close() {
+	// Shutting the socket down causes the reader goroutine to exit.
+	syscall.Shutdown(c.fd, syscall.SHUT_RDWR)
+	<-c.readerDone
+	syscall.Close(c.fd)
+}
+
+// call makes a call to procedure proc with the given credentials and encoded
+// arguments, and returns the encoded results.
+func (c *rpcConn) call(proc uint32, creds *Credentials, args []byte) ([]byte, error) {
+	ch := make(chan rpcReply, 1)
+	c.mu.Lock()
+	if c.err != nil {
+		err := c.err
+		c.mu.Unlock()
+		return nil, err
+	}
+	c.xid++
+	xid := c.xid
+	c.pending[xid] = ch
+	c.mu.Unlock()
+
+	var e encoder
+	e.uint32(0) // record marking header, filled in below.
+	e.uint32(xid)
+	e.uint32(msgCall)
+	e.uint32(rpcVersion)
+	e.uint32(c.prog)
+	e.uint32(c.vers)
+	e.uint32(proc)
+	creds.encode(&e)
+	e.uint32(authNone) // verifier
+	e.uint32(0)
+	e.buf = append(e.buf, args...)
+	binary.BigEndian.PutUint32(e.buf, lastFragment|uint32(len(e.buf)-4))
+
+	c.writeMu.Lock()
+	err := writeFull(c.fd, e.buf)
+	c.writeMu.Unlock()
+	if err != nil {
+		c.fail(fmt.Errorf("sending call: %v", err))
+	}
+
+	r := <-ch
+	return r.results, r.err
+}
+
+// fail terminates the connection with err, failing all outstanding calls.
+func (c *rpcConn) fail(err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.err != nil {
+		return
+	}
+	log.Warningf("nfs4: connection failed: %v", err)
+	// Callers only see EIO; err is logged above.
+	c.err = syscall.EIO
+	for xid, ch := range c.pending {
+		ch <- rpcReply{err: c.err}
+		delete(c.pending, xid)
+	}
+	// Unblock the reader goroutine if it is still running.
+	syscall.Shutdown(c.fd, syscall.SHUT_RDWR)
+}
+
+// readReplies receives replies and delivers them to their callers until the
+// connection fails.
+func (c *rpcConn) readReplies() {
+	defer close(c.readerDone)
+	for {
+		rec, err := readRecord(c.fd)
+		if err != nil {
+			c.fail(fmt.Errorf("receiving reply: %v", err))
+			return
+		}
+		d := decoder{buf: rec}
+		xid := d.uint32()
+		if msgType := d.uint32(); d.err != nil || msgType != msgReply {
+			c.fail(fmt.Errorf("received message type %d, want reply", msgType))
+			return
+		}
+		results, err := decodeReply(&d)
+		c.mu.Lock()
+		ch, ok := c.pending[xid]
+		delete(c.pending, xid)
+		c.mu.Unlock()
+		if !ok {
+			log.Warningf("nfs4: dropping reply with unknown xid %d", xid)
+			continue
+		}
+		ch <- rpcReply{results: results, err: err}
+	}
+}
+
+// decodeReply decodes the remainder of a reply message, after the
+// transaction ID and message type, and returns the procedure results.
+func decodeReply(d *decoder) ([]byte, error) {
+	switch stat := d.uint32(); stat {
+	case replyAccepted:
+		d.uint32() // verifier flavor
+		d.opaque() // verifier body
+		if acceptStat := d.uint32(); acceptStat != acceptSuccess {
+			log.Warningf("nfs4: call not accepted, accept_stat %d", acceptStat)
+			return nil, syscall.EIO
+		}
+		if d.err != nil {
+			return nil, syscall.EIO
+		}
+		return d.buf, nil
+	case replyDenied:
+		// Authentication errors are the only denial that depends on the
+		// caller.
+		if rejectStat := d.uint32(); rejectStat == 1 /* AUTH_ERROR */ {
+			return nil, syscall.EACCES
+		}
+		return nil, syscall.EIO
+	default:
+		log.Warningf("nfs4: invalid reply_stat %d", stat)
+		return nil, syscall.EIO
+	}
+}
+
+// readRecord reads a record, consisting of one or more fragments, from fd.
+func readRecord(fd int) ([]byte, error) {
+	var rec []byte
+	var hdr [4]byte
+	for {
+		if err := readFull(fd, hdr[:]); err != nil {
+			return nil, err
+		}
+		h := binary.BigEndian.Uint32(hdr[:])
+		n := h &^ lastFragment
+		if uint64(len(rec))+uint64(n) > maxRecordSize {
+			return nil, fmt.Errorf("record exceeds %d bytes", maxRecordSize)
+		}
+		frag := make([]byte, n)
+		if err := readFull(fd, frag); err != nil {
+			return nil, err
+		}
+		rec = append(rec, frag...)
+		if h&lastFragment != 0 {
+			return rec, nil
+		}
+	}
+}
+
+// readFull reads exactly len(buf) bytes from fd.
+func readFull(fd int, buf []byte) error {
+	for len(buf) > 0 {
+		n, err := syscall.Read(fd, buf)
+		if err == syscall.EINTR {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if n == 0 {
+			return io.ErrUnexpectedEOF
+		}
+		buf = buf[n:]
+	}
+	return nil
+}
+
+// writeFull writes all of buf to fd.
+func writeFull(fd int, buf []byte) error {
+	for len(buf) > 0 {
+		n, err := syscall.Write(fd, buf)
+		if err == syscall.EINTR {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		buf = buf[n:]
+	}
+	return nil
+}
@@ -0,0 +1,94 @@
+// Copyright 2021 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nfs4
+
+import (
+	"syscall"
+)
+
+// Status codes (nfsstat4) that are handled specially, or that have an
+// equivalent errno.
+const (
+	statusOK              = 0
+	statusPerm            = 1
+	statusNoEnt           = 2
+	statusIO              = 5
+	statusNXIO            = 6
+	statusAccess          = 13
+	statusExist           = 17
+	statusXDev            = 18
+	statusNotDir          = 20
+	statusIsDir           = 21
+	statusInval           = 22
+	statusFBig            = 27
+	statusNoSpc           = 28
+	statusROFS            = 30
+	statusMLink           = 31
+	statusNameTooLong     = 63
+	statusNotEmpty        = 66
+	statusDQuot           = 69
+	statusStale           = 70
+	statusBadHandle       = 10001
+	statusNotSupp         = 10004
+	statusDelay           = 10008
+	statusGrace           = 10013
+	statusFHExpired       = 10014
+	statusSymlink         = 10029
+	statusAttrNotSupp     = 10032
+	statusOpenMode        = 10038
+	statusBadName         = 10041
+	statusFileOpen        = 10046
+	statusCompleteAlready = 10054
+	statusWrongType       = 10083
+)
+
+// statusErrnos maps status codes to errnos.
+var statusErrnos = map[uint32]syscall.Errno{
+	statusPerm:        syscall.EPERM,
+	statusNoEnt:       syscall.ENOENT,
+	statusIO:          syscall.EIO,
+	statusNXIO:        syscall.ENXIO,
+	statusAccess:      syscall.EACCES,
+	statusExist:       syscall.EEXIST,
+	statusXDev:        syscall.EXDEV,
+	statusNotDir:      syscall.ENOTDIR,
+	statusIsDir:       syscall.EISDIR,
+	statusInval:       syscall.EINVAL,
+	statusFBig:        syscall.EFBIG,
+	statusNoSpc:       syscall.ENOSPC,
+	statusROFS:        syscall.EROFS,
+	statusMLink:       syscall.EMLINK,
+	statusNameTooLong: syscall.ENAMETOOLONG,
+	statusNotEmpty:    syscall.ENOTEMPTY,
+	statusDQuot:       syscall.EDQUOT,
+	statusStale:       syscall.ESTALE,
+	statusBadHandle:   syscall.ESTALE,
+	statusFHExpired:   syscall.ESTALE,
+	statusNotSupp:     syscall.EOPNOTSUPP,
+	statusAttrNotSupp: syscall.EOPNOTSUPP,
+	statusSymlink:     syscall.ELOOP,
+	statusOpenMode:    syscall.EACCES,
+	statusBadName:     syscall.EINVAL,
+	statusFileOpen:    syscall.EBUSY,
+	statusWrongType:   syscall.EINVAL,
+}
+
+// statusErrno returns the errno equivalent to status.
+func statusErrno(status uint32) syscall.Errno {
+	if errno, ok := statusErrnos[status]; ok {
+		return errno
+	}
+	return syscall.EIO
+}
@@ -0,0 +1,156 @@
+// Copyright 2021 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nfs4
+
+import (
+	"encoding/binary"
+	"errors"
+)
+
+// errShortBuffer is returned by decoder when a message is truncated.
+var errShortBuffer = errors.New("nfs4: short XDR buffer")
+
+// encoder encodes XDR (RFC 4506) data.
+type encoder struct {
+	buf []byte
+}
+
+// uint32 encodes an unsigned integer.
+func (e *encoder) uint32(v uint32) {
+	var b [4]byte
+	binary.BigEndian.PutUint32(b[:], v)
+	e.buf = append(e.buf, b[:]...)
+}
+
+// uint64 encodes an unsigned hyper integer.
+func (e *encoder) uint64(v uint64) {
+	var b [8]byte
+	binary.BigEndian.PutUint64(b[:], v)
+	e.buf = append(e.buf, b[:]...)
+}
+
+// bool encodes a boolean.
+func (e *encoder) bool(v bool) {
+	if v {
+		e.uint32(1)
+	} else {
+		e.uint32(0)
+	}
+}
+
+// fixed encodes fixed-length opaque data.
+func (e *encoder) fixed(b []byte) {
+	e.buf = append(e.buf, b...)
+	e.pad(len(b))
+}
+
+// opaque encodes variable-length opaque data.
+func (e *encoder) opaque(b []byte) {
+	e.uint32(uint32(len(b)))
+	e.fixed(b)
+}
+
+// string encodes a string.
+func (e *encoder) string(s string) {
+	e.uint32(uint32(len(s)))
+	e.buf = append(e.buf, s...)
+	e.pad(len(s))
+}
+
+// pad appends the padding that follows n bytes of opaque data.
+func (e *encoder) pad(n int) {
+	for ; n%4 != 0; n++ {
+		e.buf = append(e.buf, 0)
+	}
+}
+
+// decoder decodes XDR data.
+//
+// Errors are sticky: once decoding fails, all further calls return zero
+// values, and err returns the first error.
+type decoder struct {
+	buf []byte
+	err error
+}
+
+// take consumes n bytes, plus padding.
+func (d *decoder) take(n uint32) []byte {
+	if d.err != nil {
+		return nil
+	}
+	padded := (uint64(n) + 3) &^ 3
+	if uint64(len(d.buf)) < padded {
+		d.err = errShortBuffer
+		d.buf = nil
+		return nil
+	}
+	b := d.buf[:n]
+	d.buf = d.buf[padded:]
+	return b
+}
+
+// uint32 decodes an unsigned integer.
+func (d *decoder) uint32() uint32 {
+	b := d.take(4)
+	if b == nil {
+		return 0
+	}
+	return binary.BigEndian.Uint32(b)
+}
+
+// uint64 decodes an unsigned hyper integer.
+func (d *decoder) uint64() uint64 {
+	b := d.take(8)
+	if b == nil {
+		return 0
+	}
+	return binary.BigEndian.Uint64(b)
+}
+
+// bool decodes a boolean.
+func (d *decoder) bool() bool {
+	return d.uint32() != 0
+}
+
+// fixed decodes n bytes of fixed-length opaque data. The returned slice
+// aliases d's buffer.
+func (d *decoder) fixed(n uint32) []byte {
+	return d.take(n)
+}
+
+// opaque decodes variable-length opaque data. The returned slice aliases d's
+// buffer.
+func (d *decoder) opaque() []byte {
+	return d.take(d.uint32())
+}
+
+// string decodes a string.
+func (d *decoder) string() string {
+	return string(d.opaque())
+}
+
+// bitmap decodes a bitmap4.
+func (d *decoder) bitmap() []uint32 {
+	n := d.uint32()
+	if d.err == nil && uint64(n)*4 > uint64(len(d.buf)) {
+		d.err = errShortBuffer
+		return nil
+	}
+	bm := make([]uint32, n)
+	for i := range bm {
+		bm[i] = d.uint32()
+	}
+	return bm
+}
@@ -0,0 +1,261 @@
+// Copyright 2020 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package otlp exports OpenTelemetry trace spans.
+//
+// Spans are written as OTLP/JSON, one ExportTraceServiceRequest per line,
+// which is the format read by the OpenTelemetry Collector's "otlpjsonfile"
+// receiver. Writing to a file, rather than to a collector over the network,
+// allows spans to be exported from the sandbox, which has no network access.
+package otlp
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"gvisor.dev/gvisor/pkg/sync"
+)
+
+// TraceID identifies a trace.
+type TraceID [16]byte
+
+// String returns the hex encoding of id.
+func (id TraceID) String() string {
+	return hex.EncodeToString(id[:])
+}
+
+// IsValid returns true if id isn't all zeroes.
+func (id TraceID) IsValid() bool {
+	return id != TraceID{}
+}
+
+// DeriveTraceID returns a trace ID derived from s. It is used to correlate the
+// spans of a sandbox that wasn't given a trace context.
+func DeriveTraceID(s string) TraceID {
+	var id TraceID
+	sum := sha256.Sum256([]byte(s))
+	copy(id[:], sum[:])
+	return id
+}
+
+// SpanID identifies a span within a trace.
+type SpanID [8]byte
+
+// String returns the hex encoding of id.
+func (id SpanID) String() string {
+	return hex.EncodeToString(id[:])
+}
+
+// IsValid returns true if id isn't all zeroes.
+func (id SpanID) IsValid() bool {
+	return id != SpanID{}
+}
+
+// SpanContext identifies a span, which may be the parent of other spans.
+type SpanContext struct {
+	TraceID TraceID
+	SpanID  SpanID
+}
+
+// ParseTraceParent parses a W3C Trace Context traceparent header, e.g.
+// "00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01".
+func ParseTraceParent(s string) (SpanContext, error) {
+	var sc SpanContext
+	parts := strings.Split(s, "-")
+	if len(parts) < 4 || len(parts[0]) != 2 || parts[0] == "ff" {
+		return sc, fmt.Errorf("invalid traceparent %q", s)
+	}
+	// Later versions may append fields, but must keep these.
+	if parts[0] == "00" && len(parts) != 4 {
+		return sc, fmt.Errorf("invalid traceparent %q", s)
+	}
+	if err := decodeHex(sc.TraceID[:], parts[1]); err != nil || !sc.TraceID.IsValid() {
+		return sc, fmt.Errorf("invalid trace ID in traceparent %q", s)
+	}
+	if err := decodeHex(sc.SpanID[:], parts[2]); err != nil || !sc.SpanID.IsValid() {
+		return sc, fmt.Errorf("invalid parent ID in traceparent %q", s)
+	}
+	return sc, nil
+}
+
+func decodeHex(dst []byte, s string) error {
+	if len(s) != 2*len(dst) || strings.ToLower(s) != s {
+		return fmt.Errorf("invalid hex ID %q", s)
+	}
+	_, err := hex.Decode(dst, []byte(s))
+	return err
+}
+
+// Attribute is a span or resource attribute.
+type Attribute struct {
+	Key   string         `json:"key"`
+	Value AttributeValue `json:"value"`
+}
+
+// AttributeValue is the value of an attribute. Exactly one field is set.
+type AttributeValue struct {
+	StringValue *string `json:"stringValue,omitempty"`
+
+	// IntValue is encoded as a string, as required for 64-bit integers by
+	// the protobuf JSON mapping.
+	IntValue *string `json:"intValue,omitempty"`
+}
+
+// String returns a string attribute.
+func String(key, value string) Attribute {
+	return Attribute{Key: key, Value: AttributeValue{StringValue: &value}}
+}
+
+// Int returns an integer attribute.
+func Int(key string, value int64) Attribute {
+	s := strconv.FormatInt(value, 10)
+	return Attribute{Key: key, Value: AttributeValue{IntValue: &s}}
+}
+
+// SpanKind is the kind of a span.
+type SpanKind int
+
+// Span kinds, from the OTLP protocol.
+const (
+	SpanKindInternal SpanKind = 1
+	SpanKindServer   SpanKind = 2
+	SpanKindClient   SpanKind = 3
+)
+
+// Span is a completed span.
+type Span struct {
+	Context SpanContext
+	Parent  SpanID
+	Name    string
+	Kind    SpanKind
+	Start   time.Time
+	End     time.Time
+	Attrs   []Attribute
+
+	// Err is the error with which the operation failed, if any.
+	Err error
+}
+
+// Exporter writes spans to a file as OTLP/JSON.
+type Exporter struct {
+	// mu serializes writes to w, so that lines aren't interleaved.
+	mu sync.Mutex
+	w  io.Writer
+
+	resource []Attribute
+}
+
+// NewExporter returns an Exporter that writes spans to w. resource describes
+// the process that emits the spans, e.g. with "service.name".
+func NewExporter(w io.Writer, resource ...Attribute) *Exporter {
+	return &Exporter{
+		w:        w,
+		resource: resource,
+	}
+}
+
+// The following types are the OTLP/JSON encoding of an
+// ExportTraceServiceRequest. Trace and span IDs are hex-encoded, as specified
+// by OTLP, rather than base64-encoded as in the protobuf JSON mapping.
+type exportRequest struct {
+	ResourceSpans []resourceSpans `json:"resourceSpans"`
+}
+
+type resourceSpans struct {
+	Resource   resource     `json:"resource"`
+	ScopeSpans []scopeSpans `json:"scopeSpans"`
+}
+
+type resource struct {
+	Attributes []Attribute `json:"attributes,omitempty"`
+}
+
+type scopeSpans struct {
+	Scope scope      `json:"scope"`
+	Spans []jsonSpan `json:"spans"`
+}
+
+type scope struct {
+	Name string `json:"name"`
+}
+
+type jsonSpan struct {
+	TraceID           string      `json:"traceId"`
+	SpanID            string      `json:"spanId"`
+	ParentSpanID      string      `json:"parentSpanId,omitempty"`
+	Name              string      `json:"name"`
+	Kind              SpanKind    `json:"kind"`
+	StartTimeUnixNano string      `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string      `json:"endTimeUnixNano"`
+	Attributes        []Attribute `json:"attributes,omitempty"`
+	Status            *status     `json:"status,omitempty"`
+}
+
+type status struct {
+	Message string `json:"message,omitempty"`
+	Code    int    `json:"code"`
+}
+
+// statusCodeError is the OTLP status code of a failed operation.
+const statusCodeError = 2
+
+// Export writes spans as a single line.
+func (e *Exporter) Export(spans ...Span) error {
+	req := exportRequest{
+		ResourceSpans: []resourceSpans{{
+			Resource: resource{Attributes: e.resource},
+			ScopeSpans: []scopeSpans{{
+				Scope: scope{Name: "gvisor.dev/gvisor"},
+				Spans: make([]jsonSpan, 0, len(spans)),
+			}},
+		}},
+	}
+	for _, s := range spans {
+		js := jsonSpan{
+			TraceID:           s.Context.TraceID.String(),
+			SpanID:            s.Context.SpanID.String(),
+			Name:              s.Name,
+			Kind:              s.Kind,
+			StartTimeUnixNano: strconv.FormatInt(s.Start.UnixNano(), 10),
+			EndTimeUnixNano:   strconv.FormatInt(s.End.UnixNano(), 10),
+			Attributes:        s.Attrs,
+		}
+		if js.Kind == 0 {
+			js.Kind = SpanKindInternal
+		}
+		if s.Parent.IsValid() {
+			js.ParentSpanID = s.Parent.String()
+		}
+		if s.Err != nil {
+			js.Status = &status{Message: s.Err.Error(), Code: statusCodeError}
+		}
+		req.ResourceSpans[0].ScopeSpans[0].Spans = append(req.ResourceSpans[0].ScopeSpans[0].Spans, js)
+	}
+	b, err := json.Marshal(&req)
+	if err != nil {
+		return err
+	}
+	b = append(b, '\n')
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	_, err = e.w.Write(b)
+	return err
+}
@@ -0,0 +1,107 @@
+// Copyright 2020 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package otlp
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestParseTraceParent(t *testing.T) {
+	sc, err := ParseTraceParent("00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01")
+	if err != nil {
+		t.Fatalf("ParseTraceParent failed: %v", err)
+	}
+	if got, want := sc.TraceID.String(), "0af7651916cd43dd8448eb211c80319c"; got != want {
+		t.Errorf("got trace ID %s, want %s", got, want)
+	}
+	if got, want := sc.SpanID.String(), "b7ad6b7169203331"; got != want {
+		t.Errorf("got span ID %s, want %s", got, want)
+	}
+
+	for _, s := range []string{
+		"",
+		"00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331",
+		"00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01-extra",
+		"ff-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01",
+		"00-00000000000000000000000000000000-b7ad6b7169203331-01",
+		"00-0af7651916cd43dd8448eb211c80319c-0000000000000000-01",
+		"00-0AF7651916CD43DD8448EB211C80319C-b7ad6b7169203331-01",
+		"00-0af7651916cd43dd8448eb211c8031-b7ad6b7169203331-01",
+	} {
+		if _, err := ParseTraceParent(s); err == nil {
+			t.Errorf("ParseTraceParent(%q) succeeded, want error", s)
+		}
+	}
+}
+
+func TestExport(t *testing.T) {
+	var buf bytes.Buffer
+	parent := SpanContext{TraceID: DeriveTraceID("sandbox"), SpanID: SpanID{1}}
+	tracer := NewTracer(NewExporter(&buf, String("service.name", "test")), parent)
+
+	s := tracer.Start("outer", Int("n", 1))
+	s.Start("inner").End(errors.New("failed"))
+	s.End(nil)
+	tracer.Record("recorded", time.Unix(1, 0), time.Unix(2, 0), nil)
+
+	var spans []jsonSpan
+	for _, line := range bytes.Split(bytes.TrimSpace(buf.Bytes()), []byte("\n")) {
+		var req exportRequest
+		if err := json.Unmarshal(line, &req); err != nil {
+			t.Fatalf("invalid line %q: %v", line, err)
+		}
+		if got := *req.ResourceSpans[0].Resource.Attributes[0].Value.StringValue; got != "test" {
+			t.Errorf("got service.name %q, want test", got)
+		}
+		spans = append(spans, req.ResourceSpans[0].ScopeSpans[0].Spans...)
+	}
+	if len(spans) != 3 {
+		t.Fatalf("got %d spans, want 3", len(spans))
+	}
+	inner, outer, recorded := spans[0], spans[1], spans[2]
+	for _, s := range spans {
+		if s.TraceID != parent.TraceID.String() {
+			t.Errorf("span %q has trace ID %s, want %s", s.Name, s.TraceID, parent.TraceID)
+		}
+	}
+	if outer.ParentSpanID != parent.SpanID.String() {
+		t.Errorf("outer span has parent %s, want %s", outer.ParentSpanID, parent.SpanID)
+	}
+	if inner.ParentSpanID != outer.SpanID {
+		t.Errorf("inner span has parent %s, want %s", inner.ParentSpanID, outer.SpanID)
+	}
+	if inner.Status == nil || inner.Status.Code != statusCodeError || inner.Status.Message != "failed" {
+		t.Errorf("inner span has status %+v, want error", inner.Status)
+	}
+	if outer.Status != nil {
+		t.Errorf("outer span has status %+v, want none", outer.Status)
+	}
+	if recorded.StartTimeUnixNano != "1000000000" || recorded.EndTimeUnixNano != "2000000000" {
+		t.Errorf("recorded span has times %s-%s, want 1000000000-2000000000", recorded.StartTimeUnixNano, recorded.EndTimeUnixNano)
+	}
+}
+
+func TestNilTracer(t *testing.T) {
+	var tracer *Tracer
+	s := tracer.WithParent(SpanContext{TraceID: TraceID{1}}).Start("span")
+	s.AddAttributes(String("k", "v"))
+	s.Start("child").End(nil)
+	s.End(nil)
+	tracer.Record("recorded", time.Now(), time.Now(), nil)
+}
@@ -0,0 +1,165 @@
+// Copyright 2020 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package otlp
+
+import (
+	"sync/atomic"
+	"time"
+
+	"gvisor.dev/gvisor/pkg/log"
+	"gvisor.dev/gvisor/pkg/rand"
+)
+
+// Tracer creates spans in a trace and exports them.
+//
+// All methods may be called on a nil *Tracer, in which case they do nothing,
+// so that callers need not check whether tracing is enabled.
+type Tracer struct {
+	exporter *Exporter
+
+	// parent is the span of which spans started by the Tracer are children.
+	// If parent.SpanID is invalid, they are root spans.
+	parent SpanContext
+}
+
+// NewTracer returns a Tracer that exports spans with e. If parent.TraceID is
+// invalid, a random trace ID is used.
+func NewTracer(e *Exporter, parent SpanContext) *Tracer {
+	if !parent.TraceID.IsValid() {
+		rand.Read(parent.TraceID[:])
+	}
+	return &Tracer{
+		exporter: e,
+		parent:   parent,
+	}
+}
+
+// WithParent returns a Tracer that shares t's exporter and starts spans that
+// are children of parent. If parent.TraceID is invalid, t's parent is used.
+func (t *Tracer) WithParent(parent SpanContext) *Tracer {
+	if t == nil || !parent.TraceID.IsValid() {
+		return t
+	}
+	return &Tracer{
+		exporter: t.exporter,
+		parent:   parent,
+	}
+}
+
+// Start starts a span with the given name. The span is exported when it ends.
+func (t *Tracer) Start(name string, attrs ...Attribute) *ActiveSpan {
+	if t == nil {
+		return nil
+	}
+	return t.start(t.parent, name, attrs)
+}
+
+func (t *Tracer) start(parent SpanContext, name string, attrs []Attribute) *ActiveSpan {
+	s := &ActiveSpan{
+		tracer: t,
+		span: Span{
+			Context: SpanContext{TraceID: parent.TraceID},
+			Parent:  parent.SpanID,
+			Name:    name,
+			Start:   time.Now(),
+			Attrs:   attrs,
+		},
+	}
+	rand.Read(s.span.Context.SpanID[:])
+	return s
+}
+
+// Record exports a span for an operation that has already completed.
+func (t *Tracer) Record(name string, start, end time.Time, err error, attrs ...Attribute) {
+	if t == nil {
+		return
+	}
+	s := t.start(t.parent, name, attrs)
+	s.span.Start = start
+	s.span.End = end
+	s.span.Err = err
+	t.export(s.span)
+}
+
+func (t *Tracer) export(s Span) {
+	if err := t.exporter.Export(s); err != nil {
+		log.Warningf("Exporting span %q: %v", s.Name, err)
+	}
+}
+
+// ActiveSpan is a span that has started, but not ended.
+//
+// All methods may be called on a nil *ActiveSpan, in which case they do
+// nothing.
+type ActiveSpan struct {
+	tracer *Tracer
+	span   Span
+}
+
+// Context returns the context of s, which can be used as the parent of spans
+// created elsewhere.
+func (s *ActiveSpan) Context() SpanContext {
+	if s == nil {
+		return SpanContext{}
+	}
+	return s.span.Context
+}
+
+// SetKind sets the kind of s. By default, spans are SpanKindInternal.
+func (s *ActiveSpan) SetKind(kind SpanKind) {
+	if s != nil {
+		s.span.Kind = kind
+	}
+}
+
+// AddAttributes adds attributes to s.
+func (s *ActiveSpan) AddAttributes(attrs ...Attribute) {
+	if s != nil {
+		s.span.Attrs = append(s.span.Attrs, attrs...)
+	}
+}
+
+// Start starts a child of s.
+func (s *ActiveSpan) Start(name string, attrs ...Attribute) *ActiveSpan {
+	if s == nil {
+		return nil
+	}
+	return s.tracer.start(s.span.Context, name, attrs)
+}
+
+// End ends s, which failed with err if it is non-nil, and exports it.
+func (s *ActiveSpan) End(err error) {
+	if s == nil {
+		return
+	}
+	s.span.End = time.Now()
+	s.span.Err = err
+	s.tracer.export(s.span)
+}
+
+// defaultTracer holds the *Tracer returned by Default.
+var defaultTracer atomic.Value
+
+// SetDefault sets the Tracer returned by Default. t may be nil to disable
+// tracing.
+func SetDefault(t *Tracer) {
+	defaultTracer.Store(t)
+}
+
+// Default returns the process's Tracer, or nil if tracing is disabled.
+func Default() *Tracer {
+	t, _ := defaultTracer.Load().(*Tracer)
+	return t
+}
@@ -17,16 +17,24 @@ package p9
 import (
 	"errors"
 	"fmt"
+	"reflect"
 	"syscall"
+	"time"
 
 	"golang.org/x/sys/unix"
 	"gvisor.dev/gvisor/pkg/flipcall"
 	"gvisor.dev/gvisor/pkg/log"
+	"gvisor.dev/gvisor/pkg/otlp"
 	"gvisor.dev/gvisor/pkg/pool"
+	"gvisor.dev/gvisor/pkg/sentry/hostcpu"
 	"gvisor.dev/gvisor/pkg/sync"
 	"gvisor.dev/gvisor/pkg/unet"
 )
 
+// ClientSpans causes Clients created after it is set to export a span with
+// otlp.Default for each RPC.
+var ClientSpans bool
+
 // ErrOutOfTags indicates no tags are available.
 var ErrOutOfTags = errors.New("out of tags -- messages lost?")
 
@@ -116,7 +124,7 @@ type Client struct {
 	// channels is the set of all initialized channels.
 	channels []*channel
 
-	// availableChannels is a FIFO of inactive channels.
+	// availableChannels is the set of inactive channels.
 	availableChannels []*channel
 
 	// -- below corresponds to sendRecvLegacy --
@@ -140,6 +148,20 @@ type Client struct {
 //
 // If NewClient succeeds, ownership of socket is transferred to the new Client.
 func NewClient(socket *unet.Socket, messageSize uint32, version string) (*Client, error) {
+	return NewClientWithOpts(socket, messageSize, version, ClientOpts{})
+}
+
+// ClientOpts contains options for a Client.
+type ClientOpts struct {
+	// Channels is the maximum number of flipcall channels to open, which
+	// bounds the number of concurrent requests sent without falling back to
+	// the socket. The server may provide fewer. If 0, a default based on the
+	// number of CPUs is used.
+	Channels int
+}
+
+// NewClientWithOpts is like NewClient, with the given options.
+func NewClientWithOpts(socket *unet.Socket, messageSize uint32, version string, opts ClientOpts) (*Client, error) {
 	// Need at least one byte of payload.
 	if messageSize <= msgRegistry.largestFixedSize {
 		return nil, &ErrMessageTooLarge{
@@ -208,7 +230,7 @@ func NewClient(socket *unet.Socket, messageSize uint32, version string) (*Client
 	// independent channels for communication? Prefer it if possible.
 	if versionSupportsFlipcall(c.version) {
 		// Attempt to initialize IPC-based communication.
-		for i := 0; i < channelsPerClient; i++ {
+		for i := 0; i < numChannels(opts.Channels); i++ {
 			if err := c.openChannel(i); err != nil {
 				log.Warningf("error opening flipcall channel: %v", err)
 				break // Stop.
@@ -225,6 +247,9 @@ func NewClient(socket *unet.Socket, messageSize uint32, version string) (*Client
 		// No channels available: use the legacy mechanism.
 		c.sendRecv = c.sendRecvLegacySyscallErr
 	}
+	if ClientSpans {
+		c.sendRecv = tracedSendRecv(c.sendRecv)
+	}
 
 	// Ensure that the socket and channels are closed when the socket is shut
 	// down.
@@ -431,6 +456,18 @@ func (c *Client) waitAndRecv(done chan error) error {
 	}
 }
 
+// tracedSendRecv wraps the transport function sendRecv to export a span for
+// each message exchange.
+func tracedSendRecv(sendRecv func(message, message) error) func(message, message) error {
+	return func(t message, r message) error {
+		span := otlp.Default().Start("gofer "+reflect.TypeOf(t).Elem().Name(), otlp.Int("p9.msg_type", int64(t.Type())))
+		span.SetKind(otlp.SpanKindClient)
+		err := sendRecv(t, r)
+		span.End(err)
+		return err
+	}
+}
+
 // sendRecvLegacySyscallErr is a wrapper for sendRecvLegacy that converts all
 // non-syscall errors to EIO.
 func (c *Client) sendRecvLegacySyscallErr(t message, r message) error {
@@ -503,9 +540,20 @@ func (c *Client) sendRecvChannel(t message, r message) error {
 		c.channelsMu.Unlock()
 		return c.sendRecvLegacySyscallErr(t, r)
 	}
+	// Prefer the channel of the current CPU, so that requests from
+	// different CPUs don't contend for the same channels, and channel
+	// memory stays warm in the CPU's cache.
+	preferred := c.channels[int(hostcpu.GetCPU())%len(c.channels)]
 	idx := len(c.availableChannels) - 1
+	for i, ch := range c.availableChannels {
+		if ch == preferred {
+			idx = i
+			break
+		}
+	}
 	ch := c.availableChannels[idx]
-	c.availableChannels = c.availableChannels[:idx]
+	c.availableChannels[idx] = c.availableChannels[len(c.availableChannels)-1]
+	c.availableChannels = c.availableChannels[:len(c.availableChannels)-1]
 	ch.active = true
 	c.channelsWg.Add(1)
 	c.channelsMu.Unlock()
@@ -566,6 +614,25 @@ func (c *Client) Version() uint32 {
 	return c.version
 }
 
+// WaitInvalidations returns the changes made to files outside of the client
+// since sequence number seq, waiting up to timeout for some, and the sequence
+// number of the change following them. If overflow is true, changes since seq
+// were lost and anything cached about files must be invalidated.
+//
+// It returns an error, e.g. ENOSYS, if the server doesn't report changes.
+func (c *Client) WaitInvalidations(seq uint64, timeout time.Duration) (invs []Invalidation, next uint64, overflow bool, err error) {
+	if !versionSupportsTinvalidations(c.version) {
+		return nil, 0, false, syscall.ENOSYS
+	}
+	var r Rinvalidations
+	// Use the socket, so that the long poll doesn't hold one of the few
+	// channels.
+	if err := c.sendRecvLegacySyscallErr(&Tinvalidations{Seq: seq, Timeout: uint32(timeout / time.Millisecond)}, &r); err != nil {
+		return nil, 0, false, err
+	}
+	return r.Invalidations, r.Seq, r.Overflow, nil
+}
+
 // Close closes the underlying socket and channels.
 func (c *Client) Close() {
 	// unet.Socket.Shutdown() has no effect if unet.Socket.Close() has already
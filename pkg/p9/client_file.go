@@ -241,6 +241,54 @@ func (c *clientFile) Allocate(mode AllocateMode, offset, length uint64) error {
 	return c.client.sendRecv(&Tallocate{FID: c.fid, Mode: mode, Offset: offset, Length: length}, &Rallocate{})
 }
 
+// Lock implements Locker.Lock.
+func (c *clientFile) Lock(typ LockType, start, length uint64) (LockStatus, error) {
+	if atomic.LoadUint32(&c.closed) != 0 {
+		return LockStatusError, syscall.EBADF
+	}
+	if !versionSupportsTlock(c.client.version) {
+		return LockStatusError, syscall.ENOSYS
+	}
+
+	var r Rlock
+	if err := c.client.sendRecv(&Tlock{FID: c.fid, LockType: typ, Start: start, Length: length}, &r); err != nil {
+		return LockStatusError, err
+	}
+	return r.Status, nil
+}
+
+// GetLock implements Locker.GetLock.
+func (c *clientFile) GetLock(typ LockType, start, length uint64) (LockType, uint64, uint64, error) {
+	if atomic.LoadUint32(&c.closed) != 0 {
+		return LockTypeUnlock, 0, 0, syscall.EBADF
+	}
+	if !versionSupportsTlock(c.client.version) {
+		return LockTypeUnlock, 0, 0, syscall.ENOSYS
+	}
+
+	var r Rgetlock
+	if err := c.client.sendRecv(&Tgetlock{FID: c.fid, LockType: typ, Start: start, Length: length}, &r); err != nil {
+		return LockTypeUnlock, 0, 0, err
+	}
+	return r.LockType, r.Start, r.Length, nil
+}
+
+// Flock implements Locker.Flock.
+func (c *clientFile) Flock(typ LockType) (LockStatus, error) {
+	if atomic.LoadUint32(&c.closed) != 0 {
+		return LockStatusError, syscall.EBADF
+	}
+	if !versionSupportsFlock(c.client.version) {
+		return LockStatusError, syscall.ENOSYS
+	}
+
+	var r Rlock
+	if err := c.client.sendRecv(&Tlock{FID: c.fid, LockType: typ, Flags: LockFlagsFlock}, &r); err != nil {
+		return LockStatusError, err
+	}
+	return r.Status, nil
+}
+
 // Remove implements File.Remove.
 //
 // N.B. This method is no longer part of the file interface and should be
@@ -542,6 +590,53 @@ func (c *clientFile) Create(name string, openFlags OpenFlags, permissions FileMo
 	return rlcreate.File, c, rlcreate.QID, rlcreate.IoUnit, nil
 }
 
+// CreateTmpfile implements TmpfileCreator.CreateTmpfile.
+func (c *clientFile) CreateTmpfile(openFlags OpenFlags, permissions FileMode, uid UID, gid GID) (*fd.FD, File, QID, uint32, error) {
+	if atomic.LoadUint32(&c.closed) != 0 {
+		return nil, nil, QID{}, 0, syscall.EBADF
+	}
+	if !versionSupportsTtmpfile(c.client.version) {
+		return nil, nil, QID{}, 0, syscall.ENOSYS
+	}
+
+	rtmpfile := Rtmpfile{}
+	if err := c.client.sendRecv(&Ttmpfile{
+		FID:         c.fid,
+		OpenFlags:   openFlags,
+		Permissions: permissions,
+		UID:         uid,
+		GID:         gid,
+	}, &rtmpfile); err != nil {
+		return nil, nil, QID{}, 0, err
+	}
+
+	return rtmpfile.File, c, rtmpfile.QID, rtmpfile.IoUnit, nil
+}
+
+// Bind implements Binder.Bind.
+func (c *clientFile) Bind(sockType uint32, name string, permissions FileMode, uid UID, gid GID) (*fd.FD, QID, error) {
+	if atomic.LoadUint32(&c.closed) != 0 {
+		return nil, QID{}, syscall.EBADF
+	}
+	if !versionSupportsTbind(c.client.version) {
+		return nil, QID{}, syscall.ENOSYS
+	}
+
+	rbind := Rbind{}
+	if err := c.client.sendRecv(&Tbind{
+		Directory:   c.fid,
+		SockType:    sockType,
+		Name:        name,
+		Permissions: permissions,
+		UID:         uid,
+		GID:         gid,
+	}, &rbind); err != nil {
+		return nil, QID{}, err
+	}
+
+	return rbind.File, rbind.QID, nil
+}
+
 // Mkdir implements File.Mkdir.
 func (c *clientFile) Mkdir(name string, permissions FileMode, uid UID, gid GID) (QID, error) {
 	if atomic.LoadUint32(&c.closed) != 0 {
@@ -662,6 +757,26 @@ func (c *clientFile) RenameAt(oldname string, newdir File, newname string) error
 	return c.client.sendRecv(&Trenameat{OldDirectory: c.fid, OldName: oldname, NewDirectory: clientNewDir.fid, NewName: newname}, &Rrenameat{})
 }
 
+// RenameAtFlags implements FlagRenamer.RenameAtFlags.
+func (c *clientFile) RenameAtFlags(oldname string, newdir File, newname string, flags uint32) error {
+	if atomic.LoadUint32(&c.closed) != 0 {
+		return syscall.EBADF
+	}
+	if !versionSupportsTrenameat2(c.client.version) {
+		return syscall.ENOSYS
+	}
+
+	clientNewDir, ok := newdir.(*clientFile)
+	if !ok {
+		return syscall.EBADF
+	}
+
+	return c.client.sendRecv(&Trenameat2{
+		Trenameat: Trenameat{OldDirectory: c.fid, OldName: oldname, NewDirectory: clientNewDir.fid, NewName: newname},
+		Flags:     flags,
+	}, &Rrenameat2{})
+}
+
 // UnlinkAt implements File.UnlinkAt.
 func (c *clientFile) UnlinkAt(name string, flags uint32) error {
 	if atomic.LoadUint32(&c.closed) != 0 {
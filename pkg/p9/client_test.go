@@ -17,6 +17,7 @@ package p9
 import (
 	"syscall"
 	"testing"
+	"time"
 
 	"gvisor.dev/gvisor/pkg/unet"
 )
@@ -61,6 +62,135 @@ func TestVersion(t *testing.T) {
 	}
 }
 
+func TestChannels(t *testing.T) {
+	for _, tc := range []struct {
+		name           string
+		serverChannels int
+		clientChannels int
+		want           int
+	}{
+		{
+			name: "default",
+			want: channelsPerClient,
+		},
+		{
+			name:           "client limited",
+			serverChannels: 8,
+			clientChannels: 3,
+			want:           3,
+		},
+		{
+			name:           "server limited",
+			serverChannels: 3,
+			clientChannels: 8,
+			want:           3,
+		},
+		{
+			name:           "capped",
+			serverChannels: maxChannelsPerClient + 1,
+			clientChannels: maxChannelsPerClient + 1,
+			want:           maxChannelsPerClient,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			serverSocket, clientSocket, err := unet.SocketPair(false)
+			if err != nil {
+				t.Fatalf("socketpair got err %v expected nil", err)
+			}
+			defer clientSocket.Close()
+
+			s := NewServerWithOpts(nil, ServerOpts{Channels: tc.serverChannels})
+			go s.Handle(serverSocket)
+
+			c, err := NewClientWithOpts(clientSocket, DefaultMessageSize, HighestVersionString(), ClientOpts{Channels: tc.clientChannels})
+			if err != nil {
+				t.Fatalf("got %v, expected nil", err)
+			}
+			if got := len(c.channels); got != tc.want {
+				t.Errorf("got %d channels, want %d", got, tc.want)
+			}
+		})
+	}
+}
+
+// invalidator is an Attacher reporting fixed changes.
+type invalidator struct {
+	invs []Invalidation
+}
+
+// Attach implements Attacher.Attach.
+func (*invalidator) Attach() (File, error) {
+	return nil, syscall.EINVAL
+}
+
+// WaitInvalidations implements Invalidator.WaitInvalidations.
+func (i *invalidator) WaitInvalidations(seq uint64, max int, timeout time.Duration) ([]Invalidation, uint64, bool, error) {
+	if seq == 0 {
+		return nil, uint64(len(i.invs)), true, nil
+	}
+	invs := i.invs[seq-1:]
+	if len(invs) > max {
+		invs = invs[:max]
+	}
+	return invs, seq + uint64(len(invs)), false, nil
+}
+
+// TestWaitInvalidations tests that the changes reported by the server reach
+// the client.
+func TestWaitInvalidations(t *testing.T) {
+	for _, test := range []struct {
+		name     string
+		attacher Attacher
+		want     []Invalidation
+		wantErr  error
+	}{
+		{
+			name:    "unsupported",
+			wantErr: syscall.ENOSYS,
+		},
+		{
+			name: "supported",
+			attacher: &invalidator{invs: []Invalidation{
+				{QIDPath: 1},
+				{QIDPath: 2, Name: "file"},
+			}},
+			want: []Invalidation{{QIDPath: 2, Name: "file"}},
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			serverSocket, clientSocket, err := unet.SocketPair(false)
+			if err != nil {
+				t.Fatalf("socketpair got err %v expected nil", err)
+			}
+			defer clientSocket.Close()
+
+			s := NewServer(test.attacher)
+			go s.Handle(serverSocket)
+
+			c, err := NewClient(clientSocket, DefaultMessageSize, HighestVersionString())
+			if err != nil {
+				t.Fatalf("got %v, expected nil", err)
+			}
+
+			invs, next, overflow, err := c.WaitInvalidations(2, time.Second)
+			if err != test.wantErr {
+				t.Fatalf("got err %v expected %v", err, test.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if overflow || next != 3 || len(invs) != len(test.want) || (len(invs) != 0 && invs[0] != test.want[0]) {
+				t.Errorf("got (%v, %d, %t) expected (%v, 3, false)", invs, next, overflow, test.want)
+			}
+
+			// The server reports lost changes.
+			if _, _, overflow, err := c.WaitInvalidations(0, time.Second); err != nil || !overflow {
+				t.Errorf("got overflow %t, err %v expected true, nil", overflow, err)
+			}
+		})
+	}
+}
+
 func benchmarkSendRecv(b *testing.B, fn func(c *Client) func(message, message) error) {
 	b.ReportAllocs()
 
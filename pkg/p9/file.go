@@ -16,6 +16,7 @@ package p9
 
 import (
 	"syscall"
+	"time"
 
 	"gvisor.dev/gvisor/pkg/fd"
 )
@@ -29,6 +30,78 @@ type Attacher interface {
 	Attach() (File, error)
 }
 
+// Invalidator may be implemented by an Attacher whose files can be changed
+// outside of its clients, to let them cache file metadata coherently.
+type Invalidator interface {
+	// WaitInvalidations returns at most max of the changes made to files
+	// since sequence number seq, and the sequence number of the change
+	// following them. It waits up to timeout for changes if there are none.
+	//
+	// overflow is true if changes since seq were lost, in which case no
+	// changes are returned. An error is returned if changes can't be
+	// reported anymore.
+	WaitInvalidations(seq uint64, max int, timeout time.Duration) (invs []Invalidation, next uint64, overflow bool, err error)
+}
+
+// Locker may be implemented by an opened File to support record locks that
+// are coherent with other users of the file. Locks are owned by the File:
+// locks held through distinct Files conflict, as Linux's open file
+// description locks do.
+type Locker interface {
+	// Lock acquires a lock of type typ on the range of the file starting
+	// at start and spanning length bytes, or to the end of the file if
+	// length is 0. If typ is LockTypeUnlock, the range is unlocked instead.
+	// Lock doesn't wait for conflicting locks to be released; it returns
+	// LockStatusBlocked instead.
+	Lock(typ LockType, start, length uint64) (LockStatus, error)
+
+	// GetLock returns the type and range of a lock that conflicts with a
+	// lock of type typ on the given range, or LockTypeUnlock if there is
+	// none.
+	GetLock(typ LockType, start, length uint64) (LockType, uint64, uint64, error)
+
+	// Flock acquires a lock of type typ on the whole file in the style of
+	// flock(2), or releases it if typ is LockTypeUnlock. Such locks don't
+	// conflict with the locks acquired by Lock. Flock doesn't wait for
+	// conflicting locks to be released; it returns LockStatusBlocked
+	// instead.
+	Flock(typ LockType) (LockStatus, error)
+}
+
+// FlagRenamer may be implemented by a File to support renames with
+// renameat2(2) flags.
+type FlagRenamer interface {
+	// RenameAtFlags is equivalent to File.RenameAt, except that flags
+	// (RENAME_NOREPLACE, RENAME_EXCHANGE and RENAME_WHITEOUT) are applied
+	// as by renameat2(2).
+	//
+	// On the server, RenameAtFlags has a global concurrency guarantee.
+	RenameAtFlags(oldName string, newDir File, newName string, flags uint32) error
+}
+
+// TmpfileCreator may be implemented by a File representing a directory to
+// support creating unnamed files, as by open(O_TMPFILE).
+type TmpfileCreator interface {
+	// CreateTmpfile creates an unnamed regular file in this directory and
+	// opens it with the given flags, as by open(O_TMPFILE). The file may
+	// later be given a name by Link, unless flags includes O_EXCL.
+	//
+	// On the server, CreateTmpfile has a write concurrency guarantee.
+	CreateTmpfile(flags OpenFlags, permissions FileMode, uid UID, gid GID) (*fd.FD, File, QID, uint32, error)
+}
+
+// Binder may be implemented by a File representing a directory to support
+// creating Unix domain sockets that accept connections from outside the
+// client.
+type Binder interface {
+	// Bind creates a Unix domain socket of the given type, binds it to the
+	// given name in this directory and starts listening on it. It returns
+	// the listening socket, on which the client must accept connections.
+	//
+	// On the server, Bind has a write concurrency guarantee.
+	Bind(sockType uint32, name string, permissions FileMode, uid UID, gid GID) (*fd.FD, QID, error)
+}
+
 // File is a set of operations corresponding to a single node.
 //
 // Note that on the server side, the server logic places constraints on
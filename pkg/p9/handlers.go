@@ -22,7 +22,9 @@ import (
 	"strings"
 	"sync/atomic"
 	"syscall"
+	"time"
 
+	"golang.org/x/sys/unix"
 	"gvisor.dev/gvisor/pkg/fd"
 	"gvisor.dev/gvisor/pkg/log"
 )
@@ -154,6 +156,42 @@ func (t *Tsetattrclunk) handle(cs *connState) message {
 	return &Rsetattrclunk{}
 }
 
+const (
+	// maxInvalidationsTimeout is the maximum time a Tinvalidations request
+	// waits for changes, so that the server can be stopped promptly.
+	maxInvalidationsTimeout = 10 * time.Second
+
+	// maxInvalidationSize is the maximum encoded size of an Invalidation,
+	// with a name of NAME_MAX bytes.
+	maxInvalidationSize = 8 + 2 + 255 + 4 + 4
+)
+
+// handle implements handler.handle.
+func (t *Tinvalidations) handle(cs *connState) message {
+	inv, ok := cs.server.attacher.(Invalidator)
+	if !ok {
+		return newErr(syscall.ENOSYS)
+	}
+	timeout := time.Duration(t.Timeout) * time.Millisecond
+	if timeout > maxInvalidationsTimeout {
+		timeout = maxInvalidationsTimeout
+	}
+	// Return as many changes as fit in a message.
+	max := (int(atomic.LoadUint32(&cs.messageSize)) - int(msgRegistry.largestFixedSize)) / maxInvalidationSize
+	if max <= 0 {
+		return newErr(syscall.EINVAL)
+	}
+	invs, next, overflow, err := inv.WaitInvalidations(t.Seq, max, timeout)
+	if err != nil {
+		return newErr(err)
+	}
+	return &Rinvalidations{
+		Seq:           next,
+		Overflow:      overflow,
+		Invalidations: invs,
+	}
+}
+
 // handle implements handler.handle.
 func (t *Tremove) handle(cs *connState) message {
 	ref, ok := cs.LookupFID(t.FID)
@@ -406,6 +444,113 @@ func (t *Tlcreate) handle(cs *connState) message {
 	return rlcreate
 }
 
+// handle implements handler.handle.
+func (t *Ttmpfile) handle(cs *connState) message {
+	ref, ok := cs.LookupFID(t.FID)
+	if !ok {
+		return newErr(syscall.EBADF)
+	}
+	defer ref.DecRef()
+
+	creator, ok := ref.file.(TmpfileCreator)
+	if !ok {
+		return newErr(syscall.ENOSYS)
+	}
+
+	var (
+		osFile *fd.FD
+		nsf    File
+		qid    QID
+		ioUnit uint32
+		newRef *fidRef
+	)
+	if err := ref.safelyWrite(func() (err error) {
+		// Don't allow creation from non-directories or deleted directories.
+		if ref.isDeleted() || !ref.mode.IsDir() {
+			return syscall.EINVAL
+		}
+
+		// Not allowed on open directories.
+		if ref.opened {
+			return syscall.EINVAL
+		}
+
+		// Do the create.
+		osFile, nsf, qid, ioUnit, err = creator.CreateTmpfile(t.OpenFlags, t.Permissions, t.UID, t.GID)
+		if err != nil {
+			return err
+		}
+
+		// The new file has no name, so it isn't added to the path tree;
+		// it is born deleted.
+		newRef = &fidRef{
+			server:    cs.server,
+			parent:    ref,
+			file:      nsf,
+			opened:    true,
+			openFlags: t.OpenFlags,
+			mode:      ModeRegular,
+			pathNode:  newPathNode(),
+			deleted:   1,
+		}
+		ref.IncRef() // Acquire parent reference.
+		return nil
+	}); err != nil {
+		return newErr(err)
+	}
+
+	// Replace the FID reference.
+	cs.InsertFID(t.FID, newRef)
+
+	rtmpfile := &Rtmpfile{Rlopen: Rlopen{QID: qid, IoUnit: ioUnit}}
+	rtmpfile.SetFilePayload(osFile)
+	return rtmpfile
+}
+
+// handle implements handler.handle.
+func (t *Tbind) handle(cs *connState) message {
+	if err := checkSafeName(t.Name); err != nil {
+		return newErr(err)
+	}
+
+	ref, ok := cs.LookupFID(t.Directory)
+	if !ok {
+		return newErr(syscall.EBADF)
+	}
+	defer ref.DecRef()
+
+	binder, ok := ref.file.(Binder)
+	if !ok {
+		return newErr(syscall.ENOSYS)
+	}
+
+	var (
+		osFile *fd.FD
+		qid    QID
+	)
+	if err := ref.safelyWrite(func() (err error) {
+		// Don't allow bind on deleted files.
+		if ref.isDeleted() || !ref.mode.IsDir() {
+			return syscall.EINVAL
+		}
+
+		// Not allowed on open directories.
+		if ref.opened {
+			return syscall.EINVAL
+		}
+
+		// Do the bind.
+		osFile, qid, err = binder.Bind(t.SockType, t.Name, t.Permissions, t.UID, t.GID)
+		return err
+	}); err != nil {
+		return newErr(err)
+	}
+
+	rbind := &Rbind{QID: qid}
+	rbind.SetFilePayload(osFile)
+	return rbind
+}
+
 // handle implements handler.handle.
 func (t *Tsymlink) handle(cs *connState) message {
 	rsymlink, err := t.do(cs, NoUID)
@@ -539,6 +684,68 @@ func (t *Trenameat) handle(cs *connState) message {
 	return &Rrenameat{}
 }
 
+// handle implements handler.handle.
+func (t *Trenameat2) handle(cs *connState) message {
+	if err := checkSafeName(t.OldName); err != nil {
+		return newErr(err)
+	}
+	if err := checkSafeName(t.NewName); err != nil {
+		return newErr(err)
+	}
+
+	ref, ok := cs.LookupFID(t.OldDirectory)
+	if !ok {
+		return newErr(syscall.EBADF)
+	}
+	defer ref.DecRef()
+
+	refTarget, ok := cs.LookupFID(t.NewDirectory)
+	if !ok {
+		return newErr(syscall.EBADF)
+	}
+	defer refTarget.DecRef()
+
+	renamer, ok := ref.file.(FlagRenamer)
+	if !ok {
+		return newErr(syscall.ENOSYS)
+	}
+
+	// Perform the rename holding the global lock.
+	if err := ref.safelyGlobal(func() (err error) {
+		// Don't allow renaming across deleted directories.
+		if ref.isDeleted() || !ref.mode.IsDir() || refTarget.isDeleted() || !refTarget.mode.IsDir() {
+			return syscall.EINVAL
+		}
+
+		// Not allowed on open directories.
+		if ref.opened {
+			return syscall.EINVAL
+		}
+
+		// Attempt the actual rename. Unlike Trenameat, this isn't
+		// short-circuited for the same file, since whether that succeeds
+		// depends on flags.
+		if err := renamer.RenameAtFlags(t.OldName, refTarget.file, t.NewName, t.Flags); err != nil {
+			return err
+		}
+
+		// Update the path tree.
+		if ref.pathNode == refTarget.pathNode && t.OldName == t.NewName {
+			return nil
+		}
+		if t.Flags&unix.RENAME_EXCHANGE != 0 {
+			ref.exchangeChildWith(t.OldName, refTarget, t.NewName)
+		} else {
+			ref.renameChildTo(t.OldName, refTarget, t.NewName)
+		}
+		return nil
+	}); err != nil {
+		return newErr(err)
+	}
+
+	return &Rrenameat2{}
+}
+
 // handle implements handler.handle.
 func (t *Tunlinkat) handle(cs *connState) message {
 	if err := checkSafeName(t.Name); err != nil {
@@ -915,6 +1122,64 @@ func (t *Tallocate) handle(cs *connState) message {
 	return &Rallocate{}
 }
 
+// handle implements handler.handle.
+func (t *Tlock) handle(cs *connState) message {
+	ref, ok := cs.LookupFID(t.FID)
+	if !ok {
+		return newErr(syscall.EBADF)
+	}
+	defer ref.DecRef()
+
+	var status LockStatus
+	if err := ref.safelyRead(func() (err error) {
+		// Has it been opened already?
+		if !ref.opened {
+			return syscall.EINVAL
+		}
+		locker, ok := ref.file.(Locker)
+		if !ok {
+			return syscall.ENOSYS
+		}
+		if t.Flags&LockFlagsFlock != 0 {
+			status, err = locker.Flock(t.LockType)
+		} else {
+			status, err = locker.Lock(t.LockType, t.Start, t.Length)
+		}
+		return err
+	}); err != nil {
+		return newErr(err)
+	}
+
+	return &Rlock{Status: status}
+}
+
+// handle implements handler.handle.
+func (t *Tgetlock) handle(cs *connState) message {
+	ref, ok := cs.LookupFID(t.FID)
+	if !ok {
+		return newErr(syscall.EBADF)
+	}
+	defer ref.DecRef()
+
+	var r Rgetlock
+	if err := ref.safelyRead(func() (err error) {
+		// Has it been opened already?
+		if !ref.opened {
+			return syscall.EINVAL
+		}
+		locker, ok := ref.file.(Locker)
+		if !ok {
+			return syscall.ENOSYS
+		}
+		r.LockType, r.Start, r.Length, err = locker.GetLock(t.LockType, t.Start, t.Length)
+		return err
+	}); err != nil {
+		return newErr(err)
+	}
+
+	return &r
+}
+
 // handle implements handler.handle.
 func (t *Txattrwalk) handle(cs *connState) message {
 	ref, ok := cs.LookupFID(t.FID)
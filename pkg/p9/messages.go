@@ -375,6 +375,94 @@ func (r *Rsetattrclunk) String() string {
 	return "Rsetattrclunk{}"
 }
 
+// Tinvalidations is a request for the changes made to files outside of the
+// client since sequence number Seq.
+//
+// The server replies once it has any, or after Timeout milliseconds.
+type Tinvalidations struct {
+	// Seq is the sequence number of the first change to return.
+	Seq uint64
+
+	// Timeout is the maximum time to wait for changes, in milliseconds.
+	Timeout uint32
+}
+
+// decode implements encoder.decode.
+func (t *Tinvalidations) decode(b *buffer) {
+	t.Seq = b.Read64()
+	t.Timeout = b.Read32()
+}
+
+// encode implements encoder.encode.
+func (t *Tinvalidations) encode(b *buffer) {
+	b.Write64(t.Seq)
+	b.Write32(t.Timeout)
+}
+
+// Type implements message.Type.
+func (*Tinvalidations) Type() MsgType {
+	return MsgTinvalidations
+}
+
+// String implements fmt.Stringer.
+func (t *Tinvalidations) String() string {
+	return fmt.Sprintf("Tinvalidations{Seq: %d, Timeout: %d}", t.Seq, t.Timeout)
+}
+
+// Rinvalidations is an invalidations response.
+type Rinvalidations struct {
+	// Seq is the sequence number of the next change.
+	Seq uint64
+
+	// Overflow indicates that changes were lost, e.g. because the server
+	// didn't retain them. The client must then invalidate everything it
+	// caches.
+	Overflow bool
+
+	// Invalidations are the changes.
+	Invalidations []Invalidation
+}
+
+// decode implements encoder.decode.
+func (r *Rinvalidations) decode(b *buffer) {
+	r.Seq = b.Read64()
+	r.Overflow = b.Read8() != 0
+	n := b.Read32()
+	r.Invalidations = r.Invalidations[:0]
+	for i := 0; i < int(n); i++ {
+		var inv Invalidation
+		inv.decode(b)
+		if b.isOverrun() {
+			break
+		}
+		r.Invalidations = append(r.Invalidations, inv)
+	}
+}
+
+// encode implements encoder.encode.
+func (r *Rinvalidations) encode(b *buffer) {
+	b.Write64(r.Seq)
+	if r.Overflow {
+		b.Write8(1)
+	} else {
+		b.Write8(0)
+	}
+	b.Write32(uint32(len(r.Invalidations)))
+	for _, inv := range r.Invalidations {
+		inv.encode(b)
+	}
+}
+
+// Type implements message.Type.
+func (*Rinvalidations) Type() MsgType {
+	return MsgRinvalidations
+}
+
+// String implements fmt.Stringer.
+func (r *Rinvalidations) String() string {
+	return fmt.Sprintf("Rinvalidations{Seq: %d, Overflow: %t, Invalidations: %v}", r.Seq, r.Overflow, r.Invalidations)
+}
+
 // Tremove is a remove request.
 //
 // This will eventually be replaced by Tunlinkat.
@@ -878,6 +966,204 @@ func (r *Rrenameat) String() string {
 	return "Rrenameat{}"
 }
 
+// Trenameat2 is a rename request with renameat2(2) flags.
+type Trenameat2 struct {
+	Trenameat
+
+	// Flags are the renameat2(2) flags.
+	Flags uint32
+}
+
+// decode implements encoder.decode.
+func (t *Trenameat2) decode(b *buffer) {
+	t.Trenameat.decode(b)
+	t.Flags = b.Read32()
+}
+
+// encode implements encoder.encode.
+func (t *Trenameat2) encode(b *buffer) {
+	t.Trenameat.encode(b)
+	b.Write32(t.Flags)
+}
+
+// Type implements message.Type.
+func (*Trenameat2) Type() MsgType {
+	return MsgTrenameat2
+}
+
+// String implements fmt.Stringer.
+func (t *Trenameat2) String() string {
+	return fmt.Sprintf("Trenameat2{OldDirectoryFID: %d, OldName: %s, NewDirectoryFID: %d, NewName: %s, Flags: %#x}", t.OldDirectory, t.OldName, t.NewDirectory, t.NewName, t.Flags)
+}
+
+// Rrenameat2 is a rename response.
+type Rrenameat2 struct {
+}
+
+// decode implements encoder.decode.
+func (*Rrenameat2) decode(*buffer) {
+}
+
+// encode implements encoder.encode.
+func (*Rrenameat2) encode(*buffer) {
+}
+
+// Type implements message.Type.
+func (*Rrenameat2) Type() MsgType {
+	return MsgRrenameat2
+}
+
+// String implements fmt.Stringer.
+func (r *Rrenameat2) String() string {
+	return "Rrenameat2{}"
+}
+
+// Ttmpfile is a request to create an unnamed file, as by open(O_TMPFILE).
+type Ttmpfile struct {
+	// FID is the directory FID.
+	//
+	// This becomes the new file.
+	FID FID
+
+	// OpenFlags is the open mode (O_RDWR, etc.), optionally including
+	// OpenExclusive.
+	OpenFlags OpenFlags
+
+	// Permissions is the set of permission bits.
+	Permissions FileMode
+
+	// UID is the user ID to use for creating the file.
+	UID UID
+
+	// GID is the group ID to use for creating the file.
+	GID GID
+}
+
+// decode implements encoder.decode.
+func (t *Ttmpfile) decode(b *buffer) {
+	t.FID = b.ReadFID()
+	t.OpenFlags = b.ReadOpenFlags()
+	t.Permissions = b.ReadPermissions()
+	t.UID = b.ReadUID()
+	t.GID = b.ReadGID()
+}
+
+// encode implements encoder.encode.
+func (t *Ttmpfile) encode(b *buffer) {
+	b.WriteFID(t.FID)
+	b.WriteOpenFlags(t.OpenFlags)
+	b.WritePermissions(t.Permissions)
+	b.WriteUID(t.UID)
+	b.WriteGID(t.GID)
+}
+
+// Type implements message.Type.
+func (*Ttmpfile) Type() MsgType {
+	return MsgTtmpfile
+}
+
+// String implements fmt.Stringer.
+func (t *Ttmpfile) String() string {
+	return fmt.Sprintf("Ttmpfile{FID: %d, OpenFlags: %s, Permissions: 0o%o, UID: %d, GID: %d}", t.FID, t.OpenFlags, t.Permissions, t.UID, t.GID)
+}
+
+// Rtmpfile is a Ttmpfile response.
+//
+// The encode, decode, etc. methods are inherited from Rlopen.
+type Rtmpfile struct {
+	Rlopen
+}
+
+// Type implements message.Type.
+func (*Rtmpfile) Type() MsgType {
+	return MsgRtmpfile
+}
+
+// String implements fmt.Stringer.
+func (r *Rtmpfile) String() string {
+	return fmt.Sprintf("Rtmpfile{QID: %s, IoUnit: %d, File: %v}", r.QID, r.IoUnit, r.File)
+}
+
+// Tbind is a request to create a listening Unix domain socket.
+type Tbind struct {
+	// Directory is the directory in which to create the socket file.
+	Directory FID
+
+	// SockType is the socket type, as passed to socket(2).
+	SockType uint32
+
+	// Name is the name of the socket file.
+	Name string
+
+	// Permissions is the set of permission bits of the socket file.
+	Permissions FileMode
+
+	// UID is the owner of the socket file.
+	UID UID
+
+	// GID is the group of the socket file.
+	GID GID
+}
+
+// decode implements encoder.decode.
+func (t *Tbind) decode(b *buffer) {
+	t.Directory = b.ReadFID()
+	t.SockType = b.Read32()
+	t.Name = b.ReadString()
+	t.Permissions = b.ReadPermissions()
+	t.UID = b.ReadUID()
+	t.GID = b.ReadGID()
+}
+
+// encode implements encoder.encode.
+func (t *Tbind) encode(b *buffer) {
+	b.WriteFID(t.Directory)
+	b.Write32(t.SockType)
+	b.WriteString(t.Name)
+	b.WritePermissions(t.Permissions)
+	b.WriteUID(t.UID)
+	b.WriteGID(t.GID)
+}
+
+// Type implements message.Type.
+func (*Tbind) Type() MsgType {
+	return MsgTbind
+}
+
+// String implements fmt.Stringer.
+func (t *Tbind) String() string {
+	return fmt.Sprintf("Tbind{DirectoryFID: %d, SockType: %d, Name: %s, Permissions: 0o%o, UID: %d, GID: %d}", t.Directory, t.SockType, t.Name, t.Permissions, t.UID, t.GID)
+}
+
+// Rbind is a Tbind response.
+type Rbind struct {
+	// QID is the socket file's QID.
+	QID QID
+
+	// File is the listening socket.
+	filePayload
+}
+
+// decode implements encoder.decode.
+func (r *Rbind) decode(b *buffer) {
+	r.QID.decode(b)
+}
+
+// encode implements encoder.encode.
+func (r *Rbind) encode(b *buffer) {
+	r.QID.encode(b)
+}
+
+// Type implements message.Type.
+func (*Rbind) Type() MsgType {
+	return MsgRbind
+}
+
+// String implements fmt.Stringer.
+func (r *Rbind) String() string {
+	return fmt.Sprintf("Rbind{QID: %s, File: %v}", r.QID, r.File)
+}
+
 // Tunlinkat is an unlink request.
 type Tunlinkat struct {
 	// Directory is the originating directory.
@@ -2070,6 +2356,177 @@ func (r *Rfsync) String() string {
 	return "Rfsync{}"
 }
 
+// Tlock is a request to acquire or release a record lock.
+type Tlock struct {
+	// FID is the opened file to lock.
+	FID FID
+
+	// LockType is the type of the lock, or LockTypeUnlock to release it.
+	LockType LockType
+
+	// Flags are the request flags.
+	Flags LockFlags
+
+	// Start and Length are the locked range. A Length of 0 extends the
+	// range to the end of the file.
+	Start  uint64
+	Length uint64
+
+	// ProcID and ClientID identify the lock owner in the client. They are
+	// informational only.
+	ProcID   uint32
+	ClientID string
+}
+
+// decode implements encoder.decode.
+func (t *Tlock) decode(b *buffer) {
+	t.FID = b.ReadFID()
+	t.LockType = LockType(b.Read8())
+	t.Flags = LockFlags(b.Read32())
+	t.Start = b.Read64()
+	t.Length = b.Read64()
+	t.ProcID = b.Read32()
+	t.ClientID = b.ReadString()
+}
+
+// encode implements encoder.encode.
+func (t *Tlock) encode(b *buffer) {
+	b.WriteFID(t.FID)
+	b.Write8(uint8(t.LockType))
+	b.Write32(uint32(t.Flags))
+	b.Write64(t.Start)
+	b.Write64(t.Length)
+	b.Write32(t.ProcID)
+	b.WriteString(t.ClientID)
+}
+
+// Type implements message.Type.
+func (*Tlock) Type() MsgType {
+	return MsgTlock
+}
+
+// String implements fmt.Stringer.
+func (t *Tlock) String() string {
+	return fmt.Sprintf("Tlock{FID: %d, LockType: %s, Flags: %#x, Start: %d, Length: %d, ProcID: %d, ClientID: %s}", t.FID, t.LockType, t.Flags, t.Start, t.Length, t.ProcID, t.ClientID)
+}
+
+// Rlock is a lock response.
+type Rlock struct {
+	// Status is the result of the request.
+	Status LockStatus
+}
+
+// decode implements encoder.decode.
+func (r *Rlock) decode(b *buffer) {
+	r.Status = LockStatus(b.Read8())
+}
+
+// encode implements encoder.encode.
+func (r *Rlock) encode(b *buffer) {
+	b.Write8(uint8(r.Status))
+}
+
+// Type implements message.Type.
+func (*Rlock) Type() MsgType {
+	return MsgRlock
+}
+
+// String implements fmt.Stringer.
+func (r *Rlock) String() string {
+	return fmt.Sprintf("Rlock{Status: %s}", r.Status)
+}
+
+// Tgetlock is a request for a record lock that conflicts with a lock.
+type Tgetlock struct {
+	// FID is the opened file to test.
+	FID FID
+
+	// LockType is the type of the lock to test.
+	LockType LockType
+
+	// Start and Length are the range of the lock to test.
+	Start  uint64
+	Length uint64
+
+	// ProcID and ClientID identify the lock owner in the client.
+	ProcID   uint32
+	ClientID string
+}
+
+// decode implements encoder.decode.
+func (t *Tgetlock) decode(b *buffer) {
+	t.FID = b.ReadFID()
+	t.LockType = LockType(b.Read8())
+	t.Start = b.Read64()
+	t.Length = b.Read64()
+	t.ProcID = b.Read32()
+	t.ClientID = b.ReadString()
+}
+
+// encode implements encoder.encode.
+func (t *Tgetlock) encode(b *buffer) {
+	b.WriteFID(t.FID)
+	b.Write8(uint8(t.LockType))
+	b.Write64(t.Start)
+	b.Write64(t.Length)
+	b.Write32(t.ProcID)
+	b.WriteString(t.ClientID)
+}
+
+// Type implements message.Type.
+func (*Tgetlock) Type() MsgType {
+	return MsgTgetlock
+}
+
+// String implements fmt.Stringer.
+func (t *Tgetlock) String() string {
+	return fmt.Sprintf("Tgetlock{FID: %d, LockType: %s, Start: %d, Length: %d, ProcID: %d, ClientID: %s}", t.FID, t.LockType, t.Start, t.Length, t.ProcID, t.ClientID)
+}
+
+// Rgetlock is a getlock response.
+type Rgetlock struct {
+	// LockType is the type of a conflicting lock, or LockTypeUnlock if
+	// there is none.
+	LockType LockType
+
+	// Start and Length are the range of the conflicting lock.
+	Start  uint64
+	Length uint64
+
+	// ProcID and ClientID identify the owner of the conflicting lock, if
+	// known.
+	ProcID   uint32
+	ClientID string
+}
+
+// decode implements encoder.decode.
+func (r *Rgetlock) decode(b *buffer) {
+	r.LockType = LockType(b.Read8())
+	r.Start = b.Read64()
+	r.Length = b.Read64()
+	r.ProcID = b.Read32()
+	r.ClientID = b.ReadString()
+}
+
+// encode implements encoder.encode.
+func (r *Rgetlock) encode(b *buffer) {
+	b.Write8(uint8(r.LockType))
+	b.Write64(r.Start)
+	b.Write64(r.Length)
+	b.Write32(r.ProcID)
+	b.WriteString(r.ClientID)
+}
+
+// Type implements message.Type.
+func (*Rgetlock) Type() MsgType {
+	return MsgRgetlock
+}
+
+// String implements fmt.Stringer.
+func (r *Rgetlock) String() string {
+	return fmt.Sprintf("Rgetlock{LockType: %s, Start: %d, Length: %d, ProcID: %d, ClientID: %s}", r.LockType, r.Start, r.Length, r.ProcID, r.ClientID)
+}
+
 // Tstatfs is a stat request.
 type Tstatfs struct {
 	// FID is the root.
@@ -2673,6 +3130,10 @@ func init() {
 	msgRegistry.register(MsgRreaddir, func() message { return &Rreaddir{} })
 	msgRegistry.register(MsgTfsync, func() message { return &Tfsync{} })
 	msgRegistry.register(MsgRfsync, func() message { return &Rfsync{} })
+	msgRegistry.register(MsgTlock, func() message { return &Tlock{} })
+	msgRegistry.register(MsgRlock, func() message { return &Rlock{} })
+	msgRegistry.register(MsgTgetlock, func() message { return &Tgetlock{} })
+	msgRegistry.register(MsgRgetlock, func() message { return &Rgetlock{} })
 	msgRegistry.register(MsgTlink, func() message { return &Tlink{} })
 	msgRegistry.register(MsgRlink, func() message { return &Rlink{} })
 	msgRegistry.register(MsgTmkdir, func() message { return &Tmkdir{} })
@@ -2717,6 +3178,14 @@ func init() {
 	msgRegistry.register(MsgRallocate, func() message { return &Rallocate{} })
 	msgRegistry.register(MsgTsetattrclunk, func() message { return &Tsetattrclunk{} })
 	msgRegistry.register(MsgRsetattrclunk, func() message { return &Rsetattrclunk{} })
+	msgRegistry.register(MsgTinvalidations, func() message { return &Tinvalidations{} })
+	msgRegistry.register(MsgRinvalidations, func() message { return &Rinvalidations{} })
+	msgRegistry.register(MsgTrenameat2, func() message { return &Trenameat2{} })
+	msgRegistry.register(MsgRrenameat2, func() message { return &Rrenameat2{} })
+	msgRegistry.register(MsgTtmpfile, func() message { return &Ttmpfile{} })
+	msgRegistry.register(MsgRtmpfile, func() message { return &Rtmpfile{} })
+	msgRegistry.register(MsgTbind, func() message { return &Tbind{} })
+	msgRegistry.register(MsgRbind, func() message { return &Rbind{} })
 	msgRegistry.register(MsgTchannel, func() message { return &Tchannel{} })
 	msgRegistry.register(MsgRchannel, func() message { return &Rchannel{} })
 }
@@ -400,6 +400,69 @@ func TestEncodeDecode(t *testing.T) {
 				MTimeNanoSeconds: 8,
 			},
 		},
+		&Tinvalidations{
+			Seq:     1,
+			Timeout: 2,
+		},
+		&Rinvalidations{
+			Seq:      3,
+			Overflow: true,
+			Invalidations: []Invalidation{
+				{QIDPath: 4},
+				{QIDPath: 5, Name: "six", Mask: 7, Cookie: 8},
+			},
+		},
+		&Tlock{
+			FID:      1,
+			LockType: LockTypeWriteLock,
+			Flags:    LockFlagsBlock,
+			Start:    2,
+			Length:   3,
+			ProcID:   4,
+			ClientID: "five",
+		},
+		&Rlock{
+			Status: LockStatusBlocked,
+		},
+		&Tgetlock{
+			FID:      1,
+			LockType: LockTypeReadLock,
+			Start:    2,
+			Length:   3,
+			ProcID:   4,
+			ClientID: "five",
+		},
+		&Rgetlock{
+			LockType: LockTypeWriteLock,
+			Start:    6,
+			Length:   7,
+			ProcID:   8,
+			ClientID: "nine",
+		},
+		&Trenameat2{
+			Trenameat: Trenameat{
+				OldDirectory: 1,
+				OldName:      "two",
+				NewDirectory: 3,
+				NewName:      "four",
+			},
+			Flags: 5,
+		},
+		&Ttmpfile{
+			FID:         1,
+			OpenFlags:   ReadWrite,
+			Permissions: 0644,
+			UID:         2,
+			GID:         3,
+		},
+		&Tbind{
+			Directory:   1,
+			SockType:    1,
+			Name:        "a",
+			Permissions: 0755,
+			UID:         2,
+			GID:         3,
+		},
 	}
 
 	for _, enc := range objs {
@@ -47,6 +47,10 @@ const (
 	// OpenTruncate is a Tlopen flag indicating that the opened file should be
 	// truncated.
 	OpenTruncate OpenFlags = 01000
+
+	// OpenExclusive is a Ttmpfile flag indicating that the created file can
+	// never be linked into the filesystem.
+	OpenExclusive OpenFlags = 0200
 )
 
 // ConnectFlags is the mode passed to Connect operations.
@@ -93,6 +97,10 @@ func (o OpenFlags) String() string {
 		buf.WriteString("|OpenTruncate")
 		otherFlags &^= OpenTruncate
 	}
+	if otherFlags&OpenExclusive != 0 {
+		buf.WriteString("|OpenExclusive")
+		otherFlags &^= OpenExclusive
+	}
 	if otherFlags != 0 {
 		fmt.Fprintf(&buf, "|%#o", otherFlags)
 	}
@@ -315,88 +323,100 @@ type MsgType uint8
 
 // MsgType declarations.
 const (
-	MsgTlerror       MsgType = 6
-	MsgRlerror       MsgType = 7
-	MsgTstatfs       MsgType = 8
-	MsgRstatfs       MsgType = 9
-	MsgTlopen        MsgType = 12
-	MsgRlopen        MsgType = 13
-	MsgTlcreate      MsgType = 14
-	MsgRlcreate      MsgType = 15
-	MsgTsymlink      MsgType = 16
-	MsgRsymlink      MsgType = 17
-	MsgTmknod        MsgType = 18
-	MsgRmknod        MsgType = 19
-	MsgTrename       MsgType = 20
-	MsgRrename       MsgType = 21
-	MsgTreadlink     MsgType = 22
-	MsgRreadlink     MsgType = 23
-	MsgTgetattr      MsgType = 24
-	MsgRgetattr      MsgType = 25
-	MsgTsetattr      MsgType = 26
-	MsgRsetattr      MsgType = 27
-	MsgTlistxattr    MsgType = 28
-	MsgRlistxattr    MsgType = 29
-	MsgTxattrwalk    MsgType = 30
-	MsgRxattrwalk    MsgType = 31
-	MsgTxattrcreate  MsgType = 32
-	MsgRxattrcreate  MsgType = 33
-	MsgTgetxattr     MsgType = 34
-	MsgRgetxattr     MsgType = 35
-	MsgTsetxattr     MsgType = 36
-	MsgRsetxattr     MsgType = 37
-	MsgTremovexattr  MsgType = 38
-	MsgRremovexattr  MsgType = 39
-	MsgTreaddir      MsgType = 40
-	MsgRreaddir      MsgType = 41
-	MsgTfsync        MsgType = 50
-	MsgRfsync        MsgType = 51
-	MsgTlink         MsgType = 70
-	MsgRlink         MsgType = 71
-	MsgTmkdir        MsgType = 72
-	MsgRmkdir        MsgType = 73
-	MsgTrenameat     MsgType = 74
-	MsgRrenameat     MsgType = 75
-	MsgTunlinkat     MsgType = 76
-	MsgRunlinkat     MsgType = 77
-	MsgTversion      MsgType = 100
-	MsgRversion      MsgType = 101
-	MsgTauth         MsgType = 102
-	MsgRauth         MsgType = 103
-	MsgTattach       MsgType = 104
-	MsgRattach       MsgType = 105
-	MsgTflush        MsgType = 108
-	MsgRflush        MsgType = 109
-	MsgTwalk         MsgType = 110
-	MsgRwalk         MsgType = 111
-	MsgTread         MsgType = 116
-	MsgRread         MsgType = 117
-	MsgTwrite        MsgType = 118
-	MsgRwrite        MsgType = 119
-	MsgTclunk        MsgType = 120
-	MsgRclunk        MsgType = 121
-	MsgTremove       MsgType = 122
-	MsgRremove       MsgType = 123
-	MsgTflushf       MsgType = 124
-	MsgRflushf       MsgType = 125
-	MsgTwalkgetattr  MsgType = 126
-	MsgRwalkgetattr  MsgType = 127
-	MsgTucreate      MsgType = 128
-	MsgRucreate      MsgType = 129
-	MsgTumkdir       MsgType = 130
-	MsgRumkdir       MsgType = 131
-	MsgTumknod       MsgType = 132
-	MsgRumknod       MsgType = 133
-	MsgTusymlink     MsgType = 134
-	MsgRusymlink     MsgType = 135
-	MsgTlconnect     MsgType = 136
-	MsgRlconnect     MsgType = 137
-	MsgTallocate     MsgType = 138
-	MsgRallocate     MsgType = 139
-	MsgTsetattrclunk MsgType = 140
-	MsgRsetattrclunk MsgType = 141
-	MsgTchannel      MsgType = 250
-	MsgRchannel      MsgType = 251
+	MsgTlerror        MsgType = 6
+	MsgRlerror        MsgType = 7
+	MsgTstatfs        MsgType = 8
+	MsgRstatfs        MsgType = 9
+	MsgTlopen         MsgType = 12
+	MsgRlopen         MsgType = 13
+	MsgTlcreate       MsgType = 14
+	MsgRlcreate       MsgType = 15
+	MsgTsymlink       MsgType = 16
+	MsgRsymlink       MsgType = 17
+	MsgTmknod         MsgType = 18
+	MsgRmknod         MsgType = 19
+	MsgTrename        MsgType = 20
+	MsgRrename        MsgType = 21
+	MsgTreadlink      MsgType = 22
+	MsgRreadlink      MsgType = 23
+	MsgTgetattr       MsgType = 24
+	MsgRgetattr       MsgType = 25
+	MsgTsetattr       MsgType = 26
+	MsgRsetattr       MsgType = 27
+	MsgTlistxattr     MsgType = 28
+	MsgRlistxattr     MsgType = 29
+	MsgTxattrwalk     MsgType = 30
+	MsgRxattrwalk     MsgType = 31
+	MsgTxattrcreate   MsgType = 32
+	MsgRxattrcreate   MsgType = 33
+	MsgTgetxattr      MsgType = 34
+	MsgRgetxattr      MsgType = 35
+	MsgTsetxattr      MsgType = 36
+	MsgRsetxattr      MsgType = 37
+	MsgTremovexattr   MsgType = 38
+	MsgRremovexattr   MsgType = 39
+	MsgTreaddir       MsgType = 40
+	MsgRreaddir       MsgType = 41
+	MsgTfsync         MsgType = 50
+	MsgRfsync         MsgType = 51
+	MsgTlock          MsgType = 52
+	MsgRlock          MsgType = 53
+	MsgTgetlock       MsgType = 54
+	MsgRgetlock       MsgType = 55
+	MsgTlink          MsgType = 70
+	MsgRlink          MsgType = 71
+	MsgTmkdir         MsgType = 72
+	MsgRmkdir         MsgType = 73
+	MsgTrenameat      MsgType = 74
+	MsgRrenameat      MsgType = 75
+	MsgTunlinkat      MsgType = 76
+	MsgRunlinkat      MsgType = 77
+	MsgTversion       MsgType = 100
+	MsgRversion       MsgType = 101
+	MsgTauth          MsgType = 102
+	MsgRauth          MsgType = 103
+	MsgTattach        MsgType = 104
+	MsgRattach        MsgType = 105
+	MsgTflush         MsgType = 108
+	MsgRflush         MsgType = 109
+	MsgTwalk          MsgType = 110
+	MsgRwalk          MsgType = 111
+	MsgTread          MsgType = 116
+	MsgRread          MsgType = 117
+	MsgTwrite         MsgType = 118
+	MsgRwrite         MsgType = 119
+	MsgTclunk         MsgType = 120
+	MsgRclunk         MsgType = 121
+	MsgTremove        MsgType = 122
+	MsgRremove        MsgType = 123
+	MsgTflushf        MsgType = 124
+	MsgRflushf        MsgType = 125
+	MsgTwalkgetattr   MsgType = 126
+	MsgRwalkgetattr   MsgType = 127
+	MsgTucreate       MsgType = 128
+	MsgRucreate       MsgType = 129
+	MsgTumkdir        MsgType = 130
+	MsgRumkdir        MsgType = 131
+	MsgTumknod        MsgType = 132
+	MsgRumknod        MsgType = 133
+	MsgTusymlink      MsgType = 134
+	MsgRusymlink      MsgType = 135
+	MsgTlconnect      MsgType = 136
+	MsgRlconnect      MsgType = 137
+	MsgTallocate      MsgType = 138
+	MsgRallocate      MsgType = 139
+	MsgTsetattrclunk  MsgType = 140
+	MsgRsetattrclunk  MsgType = 141
+	MsgTinvalidations MsgType = 142
+	MsgRinvalidations MsgType = 143
+	MsgTrenameat2     MsgType = 144
+	MsgRrenameat2     MsgType = 145
+	MsgTtmpfile       MsgType = 146
+	MsgRtmpfile       MsgType = 147
+	MsgTbind          MsgType = 148
+	MsgRbind          MsgType = 149
+	MsgTchannel       MsgType = 250
+	MsgRchannel       MsgType = 251
 )
 
 // QIDType represents the file type for QIDs.
@@ -1082,6 +1102,116 @@ func (d *Dirent) encode(b *buffer) {
 	b.WriteString(d.Name)
 }
 
+// Invalidation is a change made to a file outside of the client.
+type Invalidation struct {
+	// QIDPath is the QID path of the changed file, or of the directory
+	// containing the changed entry if Name is set.
+	QIDPath uint64
+
+	// Name is the name of the changed entry, if any. Entries are changed
+	// when they are created, removed or renamed, and when the metadata of
+	// the file they refer to changes.
+	Name string
+
+	// Mask is the mask of the Linux inotify events describing the change,
+	// and Cookie relates the events of a rename, as in struct
+	// inotify_event.
+	Mask   uint32
+	Cookie uint32
+}
+
+// String implements fmt.Stringer.
+func (i Invalidation) String() string {
+	return fmt.Sprintf("Invalidation{QIDPath: %d, Name: %s, Mask: %#x, Cookie: %d}", i.QIDPath, i.Name, i.Mask, i.Cookie)
+}
+
+// decode implements encoder.decode.
+func (i *Invalidation) decode(b *buffer) {
+	i.QIDPath = b.Read64()
+	i.Name = b.ReadString()
+	i.Mask = b.Read32()
+	i.Cookie = b.Read32()
+}
+
+// encode implements encoder.encode.
+func (i *Invalidation) encode(b *buffer) {
+	b.Write64(i.QIDPath)
+	b.WriteString(i.Name)
+	b.Write32(i.Mask)
+	b.Write32(i.Cookie)
+}
+
+// LockType is the type of a record lock.
+type LockType uint8
+
+// Lock types, as in 9P2000.L.
+const (
+	LockTypeReadLock  LockType = 0
+	LockTypeWriteLock LockType = 1
+	LockTypeUnlock    LockType = 2
+)
+
+// String implements fmt.Stringer.
+func (t LockType) String() string {
+	switch t {
+	case LockTypeReadLock:
+		return "ReadLock"
+	case LockTypeWriteLock:
+		return "WriteLock"
+	case LockTypeUnlock:
+		return "Unlock"
+	default:
+		return fmt.Sprintf("LockType(%d)", uint8(t))
+	}
+}
+
+// LockFlags are the flags of a Tlock request.
+type LockFlags uint32
+
+// Lock flags, as in 9P2000.L.
+const (
+	// LockFlagsBlock requests that the server waits for the lock. The
+	// server may instead reply with LockStatusBlocked.
+	LockFlagsBlock LockFlags = 1
+
+	// LockFlagsReclaim requests that a lock is reclaimed after a server
+	// restart.
+	LockFlagsReclaim LockFlags = 2
+
+	// LockFlagsFlock requests a lock of the whole file in the style of
+	// flock(2), which doesn't conflict with record locks, rather than a
+	// record lock. The range of the request is ignored. This is an
+	// extension of 9P2000.L.
+	LockFlagsFlock LockFlags = 1 << 31
+)
+
+// LockStatus is the result of a Tlock request.
+type LockStatus uint8
+
+// Lock statuses, as in 9P2000.L.
+const (
+	LockStatusOK      LockStatus = 0
+	LockStatusBlocked LockStatus = 1
+	LockStatusError   LockStatus = 2
+	LockStatusGrace   LockStatus = 3
+)
+
+// String implements fmt.Stringer.
+func (s LockStatus) String() string {
+	switch s {
+	case LockStatusOK:
+		return "OK"
+	case LockStatusBlocked:
+		return "Blocked"
+	case LockStatusError:
+		return "Error"
+	case LockStatusGrace:
+		return "Grace"
+	default:
+		return fmt.Sprintf("LockStatus(%d)", uint8(s))
+	}
+}
+
 // AllocateMode are possible modes to p9.File.Allocate().
 type AllocateMode struct {
 	KeepSize      bool
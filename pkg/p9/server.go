@@ -40,6 +40,10 @@ type Server struct {
 	// for the entire server, and not per connection.
 	pathTree *pathNode
 
+	// channels is the number of channels created for each client. It is
+	// immutable.
+	channels int
+
 	// renameMu is a global lock protecting rename operations. With this
 	// lock, we can be certain that any given rename operation can safely
 	// acquire two path nodes in any order, as all other concurrent
@@ -49,9 +53,24 @@ type Server struct {
 
 // NewServer returns a new server.
 func NewServer(attacher Attacher) *Server {
+	return NewServerWithOpts(attacher, ServerOpts{})
+}
+
+// ServerOpts contains options for a Server.
+type ServerOpts struct {
+	// Channels is the number of flipcall channels created for each client,
+	// which bounds the number of concurrent requests served without falling
+	// back to the socket. If 0, a default based on the number of CPUs is
+	// used.
+	Channels int
+}
+
+// NewServerWithOpts returns a new server with the given options.
+func NewServerWithOpts(attacher Attacher, opts ServerOpts) *Server {
 	return &Server{
 		attacher: attacher,
 		pathTree: newPathNode(),
+		channels: numChannels(opts.Channels),
 	}
 }
 
@@ -293,6 +312,42 @@ func (f *fidRef) renameChildTo(oldName string, target *fidRef, newName string) {
 	}
 }
 
+// exchangeChildWith exchanges f's child oldName with target's child newName
+// in the path tree, as by renameat2(RENAME_EXCHANGE).
+//
+// Precondition: this must be called via safelyGlobal.
+func (f *fidRef) exchangeChildWith(oldName string, target *fidRef, newName string) {
+	// Detach both children before attaching either, since f and target may
+	// share a pathNode.
+	var oldRefs, newRefs []*fidRef
+	oldPathNode := f.pathNode.removeWithName(oldName, func(ref *fidRef) {
+		oldRefs = append(oldRefs, ref)
+	})
+	newPathNode := target.pathNode.removeWithName(newName, func(ref *fidRef) {
+		newRefs = append(newRefs, ref)
+	})
+	reparent := func(refs []*fidRef, parent *fidRef, name string) {
+		for _, ref := range refs {
+			ref.parent.DecRef() // Drop original reference.
+			ref.parent = parent // Change parent.
+			ref.parent.IncRef() // Acquire new one.
+			parent.pathNode.addChild(ref, name)
+			ref.file.Renamed(parent.file, name)
+		}
+	}
+	reparent(oldRefs, target, newName)
+	reparent(newRefs, f, oldName)
+
+	if oldPathNode != nil {
+		target.pathNode.addPathNodeFor(newName, oldPathNode)
+		notifyNameChange(oldPathNode)
+	}
+	if newPathNode != nil {
+		f.pathNode.addPathNodeFor(oldName, newPathNode)
+		notifyNameChange(newPathNode)
+	}
+}
+
 // safelyRead executes the given operation with the local path node locked.
 // This implies that paths will not change during the operation.
 func (f *fidRef) safelyRead(fn func() error) (err error) {
@@ -423,7 +478,7 @@ func (cs *connState) initializeChannels() (err error) {
 	}
 
 	// Create all the channels.
-	for len(cs.channels) < channelsPerClient {
+	for len(cs.channels) < cs.server.channels {
 		res := &channel{
 			done: make(chan struct{}),
 		}
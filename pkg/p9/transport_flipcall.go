@@ -42,6 +42,22 @@ var channelsPerClient = func() int {
 	return n
 }()
 
+// maxChannelsPerClient is the maximum number of channels per client, which
+// bounds the channelSize memory used by each client.
+const maxChannelsPerClient = 16
+
+// numChannels returns the number of channels to create for a client that
+// requested n channels, or channelsPerClient if n is 0.
+func numChannels(n int) int {
+	if n <= 0 {
+		return channelsPerClient
+	}
+	if n > maxChannelsPerClient {
+		return maxChannelsPerClient
+	}
+	return n
+}
+
 // channelSize is the channel size to create.
 //
 // We simply ensure that this is larger than the largest possible message size,
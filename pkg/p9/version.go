@@ -26,7 +26,7 @@ const (
 	//
 	// Clients are expected to start requesting this version number and
 	// to continuously decrement it until a Tversion request succeeds.
-	highestSupportedVersion uint32 = 12
+	highestSupportedVersion uint32 = 18
 
 	// lowestSupportedVersion is the lowest supported version X in a
 	// version string of the format 9P2000.L.Google.X.
@@ -179,3 +179,38 @@ func versionSupportsListRemoveXattr(v uint32) bool {
 func versionSupportsTsetattrclunk(v uint32) bool {
 	return v >= 12
 }
+
+// versionSupportsTinvalidations returns true if version v supports the
+// Tinvalidations message.
+func versionSupportsTinvalidations(v uint32) bool {
+	return v >= 13
+}
+
+// versionSupportsTlock returns true if version v supports the Tlock and
+// Tgetlock messages.
+func versionSupportsTlock(v uint32) bool {
+	return v >= 14
+}
+
+// versionSupportsFlock returns true if version v supports Tlock requests
+// with LockFlagsFlock.
+func versionSupportsFlock(v uint32) bool {
+	return v >= 15
+}
+
+// versionSupportsTrenameat2 returns true if version v supports the
+// Trenameat2 message.
+func versionSupportsTrenameat2(v uint32) bool {
+	return v >= 16
+}
+
+// versionSupportsTtmpfile returns true if version v supports the Ttmpfile
+// message.
+func versionSupportsTtmpfile(v uint32) bool {
+	return v >= 17
+}
+
+// versionSupportsTbind returns true if version v supports the Tbind message.
+func versionSupportsTbind(v uint32) bool {
+	return v >= 18
+}
@@ -17,6 +17,7 @@
 package arch
 
 import (
+	"encoding/binary"
 	"fmt"
 	"io"
 
@@ -59,30 +60,57 @@ type aarch64FPState []byte
 // Related code in Linux kernel: fpsimd_flush_thread().
 // FPCR = FPCR_RM_RN (0x0 << 22).
 //
-// Currently, aarch64FPState is only a space of 0x210 length for fpstate.
+// Without SVE, aarch64FPState is only a space of 0x210 length for fpstate.
 // The fp head is useless in sentry/ptrace/kvm.
 //
-func initAarch64FPState(data aarch64FPState) {
+// With SVE, aarch64FPState holds the NT_ARM_SVE regset, which begins with a
+// struct user_sve_header. The initial state is in FPSIMD format (as after
+// execve(2) or a syscall), since SVE registers are zeroed until first used.
+func initAarch64FPState(data aarch64FPState, fs *cpuid.FeatureSet) {
+	if fs == nil || !fs.UseSVE() {
+		return
+	}
+	// struct user_sve_header {
+	//	__u32 size;
+	//	__u32 max_size;
+	//	__u16 vl;
+	//	__u16 max_vl;
+	//	__u16 flags;
+	//	__u16 __reserved;
+	// };
+	vl := uint16(fs.SVEVectorLength)
+	binary.LittleEndian.PutUint32(data[0:], cpuid.SVEHeaderSize+fpsimdContextSize)
+	binary.LittleEndian.PutUint32(data[4:], uint32(len(data)))
+	binary.LittleEndian.PutUint16(data[8:], vl)
+	binary.LittleEndian.PutUint16(data[10:], vl)
+	binary.LittleEndian.PutUint16(data[12:], 0 /* SVE_PT_REGS_FPSIMD */)
 }
 
-func newAarch64FPStateSlice() []byte {
-	return alignedBytes(4096, 16)[:fpsimdContextSize]
+func newAarch64FPStateSlice(size uint) []byte {
+	capacity := uint(4096)
+	if size > capacity {
+		capacity = size
+	}
+	return alignedBytes(capacity, 16)[:size]
 }
 
 // newAarch64FPState returns an initialized floating point state.
 //
 // The returned state is large enough to store all floating point state
-// supported by host, even if the app won't use much of it due to a restricted
-// FeatureSet.
-func newAarch64FPState() aarch64FPState {
-	f := aarch64FPState(newAarch64FPStateSlice())
-	initAarch64FPState(f)
+// supported by fs, which may be nil to store only FPSIMD state.
+func newAarch64FPState(fs *cpuid.FeatureSet) aarch64FPState {
+	size := uint(fpsimdContextSize)
+	if fs != nil {
+		size, _ = fs.ExtendedStateSize()
+	}
+	f := aarch64FPState(newAarch64FPStateSlice(size))
+	initAarch64FPState(f, fs)
 	return f
 }
 
 // fork creates and returns an identical copy of the aarch64 floating point state.
 func (f aarch64FPState) fork() aarch64FPState {
-	n := aarch64FPState(newAarch64FPStateSlice())
+	n := aarch64FPState(newAarch64FPStateSlice(uint(len(f))))
 	copy(n, f)
 	return n
 }
@@ -96,7 +124,7 @@ func (f aarch64FPState) FloatingPointData() *FloatingPointData {
 //
 // This is primarily for use in tests.
 func NewFloatingPointData() *FloatingPointData {
-	return (*FloatingPointData)(&(newAarch64FPState()[0]))
+	return (*FloatingPointData)(&(newAarch64FPState(nil)[0]))
 }
 
 // State contains the common architecture bits for aarch64 (the build tag of this
@@ -318,7 +346,7 @@ func New(arch Arch, fs *cpuid.FeatureSet) Context {
 	case ARM64:
 		return &context64{
 			State{
-				aarch64FPState: newAarch64FPState(),
+				aarch64FPState: newAarch64FPState(fs),
 				FeatureSet:     fs,
 			},
 			[]aarch64FPState(nil),
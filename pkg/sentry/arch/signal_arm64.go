@@ -143,7 +143,7 @@ func (c *context64) SignalSetup(st *Stack, act *SignalAct, info *SignalInfo, alt
 	// Save the thread's floating point state.
 	c.sigFPState = append(c.sigFPState, c.aarch64FPState)
 	// Signal handler gets a clean floating point state.
-	c.aarch64FPState = newAarch64FPState()
+	c.aarch64FPState = newAarch64FPState(c.FeatureSet())
 	return nil
 }
 
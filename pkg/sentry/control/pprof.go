@@ -48,6 +48,10 @@ type Profile struct {
 
 	// done is closed when profiling is done.
 	done chan struct{}
+
+	// ring holds the profiles collected by continuous profiling, or is nil
+	// if it is disabled. It is immutable once p is registered.
+	ring *ProfileRing
 }
 
 // NewProfile returns a new Profile object.
@@ -0,0 +1,318 @@
+// Copyright 2020 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package control
+
+import (
+	"archive/tar"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"os"
+	"runtime/pprof"
+	"sort"
+	"time"
+
+	"gvisor.dev/gvisor/pkg/log"
+	"gvisor.dev/gvisor/pkg/sync"
+	"gvisor.dev/gvisor/pkg/urpc"
+)
+
+// The profile ring file starts with a profileRingHeader, followed by
+// profileRingSlots slots of equal size at profileRingHeaderSize. Each slot
+// holds a profileRecordHeader followed by the profile, as written by
+// runtime/pprof. Slots are reused in order, so the file holds the most recent
+// profiles, including those written before the sandbox crashed.
+const (
+	profileRingMagic      = "gVisorPR"
+	profileRingVersion    = 1
+	profileRingHeaderSize = 64
+	profileRingSlots      = 64
+
+	// profileRecordMagic marks valid slots.
+	profileRecordMagic = 0x464f5250 // "PROF"
+
+	// MinProfileRingSize is the minimum size of a profile ring file.
+	MinProfileRingSize = profileRingHeaderSize + profileRingSlots*64*1024
+)
+
+// Continuous profiling collects a CPU profile of continuousCPUDuration and an
+// allocation profile every continuousProfilePeriod.
+const (
+	continuousProfilePeriod = time.Minute
+	continuousCPUDuration   = 10 * time.Second
+)
+
+// Kinds of profiles in the ring.
+const (
+	profileKindCPU    = 1
+	profileKindAllocs = 2
+)
+
+// profileKindNames are the names of profile kinds, as used in dumps.
+var profileKindNames = map[uint32]string{
+	profileKindCPU:    "cpu",
+	profileKindAllocs: "allocs",
+}
+
+// profileRingHeader is the header of a profile ring file.
+type profileRingHeader struct {
+	Magic    [8]byte
+	Version  uint32
+	Slots    uint32
+	SlotSize uint64
+}
+
+// profileRecordHeader is the header of a slot.
+type profileRecordHeader struct {
+	Magic uint32
+	Kind  uint32
+
+	// Seq is the sequence number of the profile, starting at 1.
+	Seq uint64
+
+	// Start is when collection of the profile started, in nanoseconds since
+	// the epoch, and Duration is how long it lasted.
+	Start    int64
+	Duration int64
+
+	// Len is the size of the profile in bytes.
+	Len uint64
+}
+
+var profileRecordHeaderSize = uint64(binary.Size(profileRecordHeader{}))
+
+// ProfileRing is a host file that holds a bounded number of recent profiles.
+type ProfileRing struct {
+	// f is the ring file. It is immutable.
+	f *os.File
+
+	// slotSize is the size of each slot. It is immutable.
+	slotSize uint64
+
+	mu sync.Mutex
+
+	// seq is the sequence number of the last profile written.
+	seq uint64
+}
+
+// NewProfileRing returns a ProfileRing in f, which is resized to size bytes.
+// If f already holds a ring of that size, its profiles are kept.
+func NewProfileRing(f *os.File, size int64) (*ProfileRing, error) {
+	if size < MinProfileRingSize {
+		return nil, fmt.Errorf("profile ring size %d is less than the minimum of %d", size, MinProfileRingSize)
+	}
+	r := &ProfileRing{
+		f:        f,
+		slotSize: uint64(size-profileRingHeaderSize) / profileRingSlots,
+	}
+	want := profileRingHeader{
+		Version:  profileRingVersion,
+		Slots:    profileRingSlots,
+		SlotSize: r.slotSize,
+	}
+	copy(want.Magic[:], profileRingMagic)
+
+	var hdr profileRingHeader
+	buf := make([]byte, binary.Size(hdr))
+	if _, err := f.ReadAt(buf, 0); err == nil {
+		binary.Read(bytes.NewReader(buf), binary.LittleEndian, &hdr)
+		if hdr == want {
+			recs, err := r.headers()
+			if err != nil {
+				return nil, err
+			}
+			for _, rec := range recs {
+				if rec.Seq > r.seq {
+					r.seq = rec.Seq
+				}
+			}
+			return r, nil
+		}
+	}
+
+	// Discard the contents of the file, which may be a ring of another size.
+	if err := f.Truncate(0); err != nil {
+		return nil, err
+	}
+	if err := f.Truncate(size); err != nil {
+		return nil, err
+	}
+	var b bytes.Buffer
+	binary.Write(&b, binary.LittleEndian, &want)
+	if _, err := f.WriteAt(b.Bytes(), 0); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// slotOffset returns the offset of the slot of the profile numbered seq.
+func (r *ProfileRing) slotOffset(seq uint64) int64 {
+	return profileRingHeaderSize + int64(((seq-1)%profileRingSlots)*r.slotSize)
+}
+
+// headers returns the headers of the valid slots of the ring.
+func (r *ProfileRing) headers() ([]profileRecordHeader, error) {
+	var recs []profileRecordHeader
+	buf := make([]byte, profileRecordHeaderSize)
+	for i := uint64(1); i <= profileRingSlots; i++ {
+		if _, err := r.f.ReadAt(buf, r.slotOffset(i)); err != nil {
+			return nil, err
+		}
+		var rec profileRecordHeader
+		binary.Read(bytes.NewReader(buf), binary.LittleEndian, &rec)
+		if rec.Magic == profileRecordMagic && rec.Len <= r.slotSize-profileRecordHeaderSize {
+			recs = append(recs, rec)
+		}
+	}
+	sort.Slice(recs, func(i, j int) bool {
+		return recs[i].Seq < recs[j].Seq
+	})
+	return recs, nil
+}
+
+// add writes a profile of the given kind to the ring, replacing the oldest
+// one.
+func (r *ProfileRing) add(kind uint32, start time.Time, d time.Duration, data []byte) error {
+	if uint64(len(data)) > r.slotSize-profileRecordHeaderSize {
+		return fmt.Errorf("%s profile of %d bytes doesn't fit in the profile ring's slots of %d bytes", profileKindNames[kind], len(data), r.slotSize)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.seq++
+	off := r.slotOffset(r.seq)
+
+	// Invalidate the slot until the profile is written, so that a partial
+	// profile is never read back.
+	if _, err := r.f.WriteAt(make([]byte, profileRecordHeaderSize), off); err != nil {
+		return err
+	}
+	if _, err := r.f.WriteAt(data, off+int64(profileRecordHeaderSize)); err != nil {
+		return err
+	}
+	var b bytes.Buffer
+	binary.Write(&b, binary.LittleEndian, &profileRecordHeader{
+		Magic:    profileRecordMagic,
+		Kind:     kind,
+		Seq:      r.seq,
+		Start:    start.UnixNano(),
+		Duration: int64(d),
+		Len:      uint64(len(data)),
+	})
+	_, err := r.f.WriteAt(b.Bytes(), off)
+	return err
+}
+
+// dump writes the profiles of the ring to w as a tar archive, oldest first.
+func (r *ProfileRing) dump(w *tar.Writer) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	recs, err := r.headers()
+	if err != nil {
+		return err
+	}
+	for _, rec := range recs {
+		data := make([]byte, rec.Len)
+		if _, err := r.f.ReadAt(data, r.slotOffset(rec.Seq)+int64(profileRecordHeaderSize)); err != nil {
+			return err
+		}
+		start := time.Unix(0, rec.Start)
+		if err := w.WriteHeader(&tar.Header{
+			Name:    fmt.Sprintf("%s-%s.pb.gz", profileKindNames[rec.Kind], start.UTC().Format("20060102-150405")),
+			Mode:    0644,
+			Size:    int64(rec.Len),
+			ModTime: start.Add(time.Duration(rec.Duration)),
+		}); err != nil {
+			return err
+		}
+		if _, err := w.Write(data); err != nil {
+			return err
+		}
+	}
+	return w.Close()
+}
+
+// StartContinuous starts collecting profiles to r in the background, until p
+// is stopped. Each minute, a CPU profile of 10 seconds and a profile of the
+// allocations made since the sandbox started are collected, which keeps
+// the overhead of profiling low. CPU profiles requested through CPU wait for
+// continuous CPU profiles to finish.
+func (p *Profile) StartContinuous(r *ProfileRing) {
+	p.ring = r
+	go func() { // S/R-SAFE: profiling isn't part of the sandbox state.
+		for {
+			p.collectContinuous()
+			select {
+			case <-time.After(continuousProfilePeriod - continuousCPUDuration):
+			case <-p.done:
+				return
+			}
+		}
+	}()
+}
+
+// collectContinuous collects a CPU profile and an allocation profile to
+// p.ring.
+func (p *Profile) collectContinuous() {
+	var buf bytes.Buffer
+	p.cpuMu.Lock()
+	start := time.Now()
+	if err := pprof.StartCPUProfile(&buf); err != nil {
+		log.Warningf("Starting continuous CPU profile: %v", err)
+	} else {
+		select {
+		case <-time.After(continuousCPUDuration):
+		case <-p.done:
+		}
+		pprof.StopCPUProfile()
+		if err := p.ring.add(profileKindCPU, start, time.Since(start), buf.Bytes()); err != nil {
+			log.Warningf("Writing continuous CPU profile: %v", err)
+		}
+	}
+	p.cpuMu.Unlock()
+
+	buf.Reset()
+	start = time.Now()
+	if err := pprof.Lookup("allocs").WriteTo(&buf, 0); err != nil {
+		log.Warningf("Collecting continuous allocation profile: %v", err)
+		return
+	}
+	if err := p.ring.add(profileKindAllocs, start, 0, buf.Bytes()); err != nil {
+		log.Warningf("Writing continuous allocation profile: %v", err)
+	}
+}
+
+// RingDumpOpts contains options for RingDump.
+type RingDumpOpts struct {
+	// FilePayload is the destination for the dump.
+	urpc.FilePayload
+}
+
+// RingDump writes the profiles collected by continuous profiling to a tar
+// archive, oldest first. The CPU and allocation profiles are named
+// cpu-<time>.pb.gz and allocs-<time>.pb.gz respectively.
+func (p *Profile) RingDump(o *RingDumpOpts, _ *struct{}) error {
+	if p.ring == nil {
+		return fmt.Errorf("continuous profiling is disabled")
+	}
+	if len(o.FilePayload.Files) < 1 {
+		return nil // Allowed.
+	}
+
+	output := o.FilePayload.Files[0]
+	defer output.Close()
+
+	return p.ring.dump(tar.NewWriter(output))
+}
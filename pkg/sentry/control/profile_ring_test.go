@@ -0,0 +1,107 @@
+// Copyright 2020 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package control
+
+import (
+	"archive/tar"
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+)
+
+// ringContents returns the contents of the profiles in r, oldest first.
+func ringContents(t *testing.T, r *ProfileRing) []string {
+	var b bytes.Buffer
+	if err := r.dump(tar.NewWriter(&b)); err != nil {
+		t.Fatalf("dump failed: %v", err)
+	}
+	var contents []string
+	tr := tar.NewReader(&b)
+	for {
+		if _, err := tr.Next(); err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatalf("reading dump: %v", err)
+		}
+		data, err := ioutil.ReadAll(tr)
+		if err != nil {
+			t.Fatalf("reading dump: %v", err)
+		}
+		contents = append(contents, string(data))
+	}
+	return contents
+}
+
+// Tests that the ring keeps the most recent profiles, including across
+// reopening.
+func TestProfileRing(t *testing.T) {
+	f, err := ioutil.TempFile("", "profile_ring_test")
+	if err != nil {
+		t.Fatalf("TempFile failed: %v", err)
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+
+	r, err := NewProfileRing(f, MinProfileRingSize)
+	if err != nil {
+		t.Fatalf("NewProfileRing failed: %v", err)
+	}
+	if got := ringContents(t, r); len(got) != 0 {
+		t.Errorf("new ring holds %d profiles, want 0", len(got))
+	}
+
+	const n = profileRingSlots + 3
+	for i := 0; i < n; i++ {
+		if err := r.add(profileKindCPU, time.Now(), time.Second, []byte(fmt.Sprintf("profile %d", i))); err != nil {
+			t.Fatalf("add failed: %v", err)
+		}
+	}
+	if err := r.add(profileKindCPU, time.Now(), time.Second, make([]byte, r.slotSize)); err == nil {
+		t.Errorf("add of a profile larger than a slot succeeded")
+	}
+
+	check := func(r *ProfileRing) {
+		got := ringContents(t, r)
+		if len(got) != profileRingSlots {
+			t.Fatalf("ring holds %d profiles, want %d", len(got), profileRingSlots)
+		}
+		for i, c := range got {
+			if want := fmt.Sprintf("profile %d", n-profileRingSlots+i); c != want {
+				t.Errorf("profile %d: got %q, want %q", i, c, want)
+			}
+		}
+	}
+	check(r)
+
+	// Reopening the ring keeps its profiles.
+	r, err = NewProfileRing(f, MinProfileRingSize)
+	if err != nil {
+		t.Fatalf("NewProfileRing failed: %v", err)
+	}
+	check(r)
+
+	// Resizing the ring discards them.
+	r, err = NewProfileRing(f, 2*MinProfileRingSize)
+	if err != nil {
+		t.Fatalf("NewProfileRing failed: %v", err)
+	}
+	if got := ringContents(t, r); len(got) != 0 {
+		t.Errorf("resized ring holds %d profiles, want 0", len(got))
+	}
+}
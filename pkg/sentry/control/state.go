@@ -21,6 +21,7 @@ import (
 	"gvisor.dev/gvisor/pkg/sentry/kernel"
 	"gvisor.dev/gvisor/pkg/sentry/state"
 	"gvisor.dev/gvisor/pkg/sentry/watchdog"
+	"gvisor.dev/gvisor/pkg/state/statefile"
 	"gvisor.dev/gvisor/pkg/urpc"
 )
 
@@ -32,16 +33,27 @@ var ErrInvalidFiles = errors.New("exactly one file must be provided")
 type State struct {
 	Kernel   *kernel.Kernel
 	Watchdog *watchdog.Watchdog
+
+	// BeforeExit, if not nil, is called after a successful save, before the
+	// kernel is killed.
+	BeforeExit func()
 }
 
 // SaveOpts contains options for the Save RPC call.
 type SaveOpts struct {
-	// Key is used for state integrity check.
+	// Key is used for state integrity check and encryption.
 	Key []byte `json:"key"`
 
 	// Metadata is the set of metadata to prepend to the state file.
 	Metadata map[string]string `json:"metadata"`
 
+	// Compression is the state file compression setting.
+	Compression statefile.Compression `json:"compression"`
+
+	// Workers is the number of goroutines that compress the state file, or
+	// zero for the default.
+	Workers int `json:"workers"`
+
 	// FilePayload contains the destination for the state.
 	urpc.FilePayload
 }
@@ -59,9 +71,14 @@ func (s *State) Save(o *SaveOpts, _ *struct{}) error {
 		Destination: o.FilePayload.Files[0],
 		Key:         o.Key,
 		Metadata:    o.Metadata,
+		Compression: o.Compression,
+		Workers:     o.Workers,
 		Callback: func(err error) {
 			if err == nil {
 				log.Infof("Save succeeded: exiting...")
+				if s.BeforeExit != nil {
+					s.BeforeExit()
+				}
 				s.Kernel.SetSaveSuccess(false /* autosave */)
 			} else {
 				log.Warningf("Save failed: exiting...")
@@ -0,0 +1,38 @@
+// Copyright 2021 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package control
+
+import (
+	"gvisor.dev/gvisor/pkg/sentry/fsimpl/verity"
+)
+
+// VerityMeasurements is the measurement log of verity file systems.
+type VerityMeasurements struct {
+	// Measurements are the entries of the log, oldest first.
+	Measurements []verity.Measurement
+
+	// Dropped is the number of entries dropped from the log because it was
+	// full.
+	Dropped uint64
+}
+
+// Verity provides functions related to verity file systems.
+type Verity struct{}
+
+// Measurements returns the measurement log of verity file systems.
+func (*Verity) Measurements(_ *struct{}, out *VerityMeasurements) error {
+	out.Measurements, out.Dropped = verity.Measurements()
+	return nil
+}
@@ -0,0 +1,171 @@
+// Copyright 2021 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package attestdev implements the attestation devices of confidential
+// computing guests, /dev/sev-guest (AMD SEV-SNP) and /dev/tdx_guest (Intel
+// TDX), for sandboxes that run inside such a guest.
+//
+// Attestation reports are produced by the host's device, so that they
+// describe the confidential guest that the sandbox runs in; the sentry only
+// copies requests and responses between application memory and the host.
+package attestdev
+
+import (
+	"fmt"
+
+	"golang.org/x/sys/unix"
+	"gvisor.dev/gvisor/pkg/abi/linux"
+	"gvisor.dev/gvisor/pkg/sentry/devices/devproxy"
+	"gvisor.dev/gvisor/pkg/sentry/kernel"
+	"gvisor.dev/gvisor/pkg/sentry/vfs"
+	"gvisor.dev/gvisor/pkg/syserror"
+	"gvisor.dev/gvisor/pkg/usermem"
+)
+
+// Names of the devices implemented by this package, relative to /dev.
+const (
+	// SEVGuest is the AMD SEV-SNP guest device.
+	SEVGuest = "sev-guest"
+
+	// TDXGuest is the Intel TDX guest device.
+	TDXGuest = "tdx_guest"
+)
+
+// Spec returns the specification of the attestation device with the given
+// name, which must be SEVGuest or TDXGuest.
+func Spec(name string) (*devproxy.Spec, error) {
+	switch name {
+	case SEVGuest:
+		return &devproxy.Spec{
+			Name: name,
+			Ioctls: map[uint32]devproxy.Ioctl{
+				linux.SNP_GET_REPORT:      {Name: "SNP_GET_REPORT", Handler: sevIoctl},
+				linux.SNP_GET_DERIVED_KEY: {Name: "SNP_GET_DERIVED_KEY", Handler: sevIoctl},
+				linux.SNP_GET_EXT_REPORT:  {Name: "SNP_GET_EXT_REPORT", Handler: sevIoctl},
+			},
+		}, nil
+	case TDXGuest:
+		return &devproxy.Spec{
+			Name: name,
+			Ioctls: map[uint32]devproxy.Ioctl{
+				linux.TDX_CMD_GET_REPORT0: {Name: "TDX_CMD_GET_REPORT0", Handler: tdxIoctl},
+			},
+		}, nil
+	default:
+		return nil, fmt.Errorf("unknown attestation device %q", name)
+	}
+}
+
+// sevIoctl handles the ioctls of /dev/sev-guest. See
+// drivers/virt/coco/sev-guest/sev-guest.c:snp_guest_ioctl().
+func sevIoctl(t *kernel.Task, hostFD int32, request uint32, data usermem.Addr) (uintptr, error) {
+	var ioc linux.SNPGuestRequestIoctl
+	if _, err := ioc.CopyIn(t, data); err != nil {
+		return 0, err
+	}
+	reqAddr := usermem.Addr(ioc.ReqData)
+	respAddr := usermem.Addr(ioc.RespData)
+
+	var (
+		req, resp []byte
+		extReq    linux.SNPExtReportReq
+		certs     []byte
+		exitInfo2 uint64
+		hostErr   error
+	)
+	switch request {
+	case linux.SNP_GET_REPORT:
+		req = make([]byte, (*linux.SNPReportReq)(nil).SizeBytes())
+		resp = make([]byte, linux.SizeOfSNPReportResp)
+	case linux.SNP_GET_DERIVED_KEY:
+		req = make([]byte, linux.SizeOfSNPDerivedKeyReq)
+		resp = make([]byte, linux.SizeOfSNPDerivedKeyResp)
+	case linux.SNP_GET_EXT_REPORT:
+		if _, err := extReq.CopyIn(t, reqAddr); err != nil {
+			return 0, err
+		}
+		if extReq.CertsLen > linux.SEV_FW_BLOB_MAX_SIZE || extReq.CertsLen%usermem.PageSize != 0 {
+			return 0, syserror.EINVAL
+		}
+		certs = make([]byte, extReq.CertsLen)
+		resp = make([]byte, linux.SizeOfSNPReportResp)
+		exitInfo2, hostErr = hostSNPExtReport(hostFD, ioc.MsgVersion, &extReq, resp, certs)
+		// The required certificate length is returned even on failure, so
+		// that the application can retry with a larger buffer.
+		if _, err := extReq.CopyOut(t, reqAddr); err != nil {
+			return 0, err
+		}
+	default:
+		return 0, syserror.ENOTTY
+	}
+	if req != nil {
+		if _, err := t.CopyInBytes(reqAddr, req); err != nil {
+			return 0, err
+		}
+		exitInfo2, hostErr = hostSNPRequest(hostFD, request, ioc.MsgVersion, req, resp)
+	}
+
+	// Firmware and VMM errors are returned even on failure.
+	ioc.ExitInfo2 = exitInfo2
+	if _, err := ioc.CopyOut(t, data); err != nil {
+		return 0, err
+	}
+	if hostErr != nil {
+		return 0, hostErr
+	}
+	if _, err := t.CopyOutBytes(respAddr, resp); err != nil {
+		return 0, err
+	}
+	if len(certs) != 0 {
+		if _, err := t.CopyOutBytes(usermem.Addr(extReq.CertsAddress), certs); err != nil {
+			return 0, err
+		}
+	}
+	return 0, nil
+}
+
+// tdxIoctl handles the ioctls of /dev/tdx_guest. See
+// drivers/virt/coco/tdx-guest/tdx-guest.c:tdx_guest_ioctl().
+func tdxIoctl(t *kernel.Task, hostFD int32, request uint32, data usermem.Addr) (uintptr, error) {
+	var req linux.TDXReportReq
+	if _, err := req.CopyIn(t, data); err != nil {
+		return 0, err
+	}
+	if err := hostTDXReport(hostFD, &req); err != nil {
+		return 0, err
+	}
+	_, err := req.CopyOut(t, data)
+	return 0, err
+}
+
+// Register registers the device with the given name, which must be SEVGuest
+// or TDXGuest, in vfsObj. Requests to the device are forwarded to the host
+// device hostFD, which has the same name and must remain open for the
+// lifetime of vfsObj.
+func Register(vfsObj *vfs.VirtualFilesystem, name string, hostFD int) error {
+	spec, err := Spec(name)
+	if err != nil {
+		return err
+	}
+	// Both devices are misc devices with dynamically allocated minor
+	// numbers; devproxy uses the same one as the host.
+	var stat unix.Stat_t
+	if err := unix.Fstat(hostFD, &stat); err != nil {
+		return fmt.Errorf("stat host %s: %w", name, err)
+	}
+	if stat.Mode&unix.S_IFMT != unix.S_IFCHR || unix.Major(stat.Rdev) != linux.MISC_MAJOR {
+		return fmt.Errorf("host %s is not a misc device", name)
+	}
+	return devproxy.Register(vfsObj, spec, hostFD)
+}
@@ -0,0 +1,83 @@
+// Copyright 2021 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package attestdev
+
+import (
+	"reflect"
+	"testing"
+
+	"gvisor.dev/gvisor/pkg/abi/linux"
+)
+
+func TestSpec(t *testing.T) {
+	for _, test := range []struct {
+		name string
+		want []uint32
+	}{
+		{
+			name: SEVGuest,
+			want: []uint32{linux.SNP_GET_REPORT, linux.SNP_GET_DERIVED_KEY, linux.SNP_GET_EXT_REPORT},
+		},
+		{
+			name: TDXGuest,
+			want: []uint32{linux.TDX_CMD_GET_REPORT0},
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			spec, err := Spec(test.name)
+			if err != nil {
+				t.Fatalf("Spec failed: %v", err)
+			}
+			if spec.Name != test.name {
+				t.Errorf("got name %q, want %q", spec.Name, test.name)
+			}
+			// These ioctls and no others may be issued to the host device.
+			if got := spec.Requests(); !reflect.DeepEqual(got, test.want) {
+				t.Errorf("Requests() got %#x, want %#x", got, test.want)
+			}
+			// The arguments of all of them contain pointers, so none may be
+			// copied as a flat structure.
+			for request, ioctl := range spec.Ioctls {
+				if ioctl.Handler == nil {
+					t.Errorf("ioctl %s (%#x) has no handler", ioctl.Name, request)
+				}
+			}
+		})
+	}
+}
+
+func TestSpecUnknown(t *testing.T) {
+	if _, err := Spec("sgx_enclave"); err == nil {
+		t.Errorf("Spec of an unknown device succeeded")
+	}
+}
+
+// TestRequestNumbers checks the request numbers against those of the Linux
+// headers.
+func TestRequestNumbers(t *testing.T) {
+	for _, test := range []struct {
+		name    string
+		request uint32
+		want    uint32
+	}{
+		{"SNP_GET_REPORT", linux.SNP_GET_REPORT, 0xc0205300},
+		{"SNP_GET_DERIVED_KEY", linux.SNP_GET_DERIVED_KEY, 0xc0205301},
+		{"SNP_GET_EXT_REPORT", linux.SNP_GET_EXT_REPORT, 0xc0205302},
+		{"TDX_CMD_GET_REPORT0", linux.TDX_CMD_GET_REPORT0, 0xc4405401},
+	} {
+		if test.request != test.want {
+			t.Errorf("%s got %#x, want %#x", test.name, test.request, test.want)
+		}
+	}
+}
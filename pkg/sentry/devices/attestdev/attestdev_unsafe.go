@@ -0,0 +1,66 @@
+// Copyright 2021 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package attestdev
+
+import (
+	"runtime"
+	"unsafe"
+
+	"gvisor.dev/gvisor/pkg/abi/linux"
+	"gvisor.dev/gvisor/pkg/sentry/devices/devproxy"
+)
+
+// hostSNPRequest issues the SEV-SNP guest request ioctl request, with
+// request and response messages req and resp, to the host device. It returns
+// the resulting exitinfo2.
+func hostSNPRequest(hostFD int32, request uint32, msgVersion uint8, req, resp []byte) (uint64, error) {
+	ioc := linux.SNPGuestRequestIoctl{
+		MsgVersion: msgVersion,
+		ReqData:    uint64(uintptr(unsafe.Pointer(&req[0]))),
+		RespData:   uint64(uintptr(unsafe.Pointer(&resp[0]))),
+	}
+	_, err := devproxy.HostIoctl(hostFD, request, unsafe.Pointer(&ioc))
+	runtime.KeepAlive(req)
+	runtime.KeepAlive(resp)
+	return ioc.ExitInfo2, err
+}
+
+// hostSNPExtReport issues SNP_GET_EXT_REPORT to the host device, storing
+// certificates in certs. req.CertsAddress is ignored, and req.CertsLen is
+// updated with the length required by the host.
+func hostSNPExtReport(hostFD int32, msgVersion uint8, req *linux.SNPExtReportReq, resp, certs []byte) (uint64, error) {
+	hostReq := *req
+	hostReq.CertsAddress = 0
+	if len(certs) != 0 {
+		hostReq.CertsAddress = uint64(uintptr(unsafe.Pointer(&certs[0])))
+	}
+	ioc := linux.SNPGuestRequestIoctl{
+		MsgVersion: msgVersion,
+		ReqData:    uint64(uintptr(unsafe.Pointer(&hostReq))),
+		RespData:   uint64(uintptr(unsafe.Pointer(&resp[0]))),
+	}
+	_, err := devproxy.HostIoctl(hostFD, linux.SNP_GET_EXT_REPORT, unsafe.Pointer(&ioc))
+	runtime.KeepAlive(&hostReq)
+	runtime.KeepAlive(resp)
+	runtime.KeepAlive(certs)
+	req.CertsLen = hostReq.CertsLen
+	return ioc.ExitInfo2, err
+}
+
+// hostTDXReport issues TDX_CMD_GET_REPORT0 to the host device.
+func hostTDXReport(hostFD int32, req *linux.TDXReportReq) error {
+	_, err := devproxy.HostIoctl(hostFD, linux.TDX_CMD_GET_REPORT0, unsafe.Pointer(req))
+	return err
+}
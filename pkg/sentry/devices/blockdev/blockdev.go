@@ -0,0 +1,270 @@
+// Copyright 2021 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package blockdev implements block device special files backed by
+// vfs.Disks, as implemented in Linux by block/fops.c.
+package blockdev
+
+import (
+	"io"
+
+	"gvisor.dev/gvisor/pkg/abi/linux"
+	"gvisor.dev/gvisor/pkg/context"
+	"gvisor.dev/gvisor/pkg/marshal/primitive"
+	"gvisor.dev/gvisor/pkg/sentry/arch"
+	"gvisor.dev/gvisor/pkg/sentry/kernel"
+	"gvisor.dev/gvisor/pkg/sentry/vfs"
+	"gvisor.dev/gvisor/pkg/sync"
+	"gvisor.dev/gvisor/pkg/syserror"
+	"gvisor.dev/gvisor/pkg/usermem"
+)
+
+// SectorSize is the size of the logical blocks of block devices.
+const SectorSize = 512
+
+// Device is a block device whose special files are represented by FDs.
+type Device interface {
+	vfs.DiskDevice
+
+	// Ioctl implements the ioctls that are specific to the device. It
+	// returns ENOTTY for unknown requests.
+	Ioctl(ctx context.Context, fd *FD, uio usermem.IO, args arch.SyscallArguments) (uintptr, error)
+
+	// Close is called when an FD representing the device is released.
+	Close(ctx context.Context)
+}
+
+// FD implements vfs.FileDescriptionImpl for block device special files.
+//
+// +stateify savable
+type FD struct {
+	vfsfd vfs.FileDescription
+	vfs.FileDescriptionDefaultImpl
+	vfs.DentryMetadataFileDescriptionImpl
+	vfs.NoLockFD
+
+	// dev is the represented device. dev is immutable.
+	dev Device
+
+	// mu protects off.
+	mu  sync.Mutex `state:"nosave"`
+	off int64
+}
+
+// NewFD returns a FileDescription representing dev. The device is closed when
+// the FileDescription is released.
+func NewFD(dev Device, mnt *vfs.Mount, vfsd *vfs.Dentry, flags uint32) (*vfs.FileDescription, error) {
+	fd := &FD{dev: dev}
+	if err := fd.vfsfd.Init(fd, flags, mnt, vfsd, &vfs.FileDescriptionOptions{
+		UseDentryMetadata: true,
+	}); err != nil {
+		return nil, err
+	}
+	return &fd.vfsfd, nil
+}
+
+// VFSFileDescription returns the vfs.FileDescription of fd.
+func (fd *FD) VFSFileDescription() *vfs.FileDescription {
+	return &fd.vfsfd
+}
+
+// disk returns the disk backing the device, or nil if it has none.
+func (fd *FD) disk(ctx context.Context) (vfs.Disk, error) {
+	disk, err := fd.dev.Disk(ctx)
+	if err == syserror.ENXIO {
+		// Devices without backing disks have no capacity.
+		return nil, nil
+	}
+	return disk, err
+}
+
+// Size returns the size of the device in bytes, which is 0 if the device has
+// no backing disk.
+func (fd *FD) Size(ctx context.Context) (int64, error) {
+	disk, err := fd.disk(ctx)
+	if err != nil || disk == nil {
+		return 0, err
+	}
+	defer disk.DecRef(ctx)
+	return disk.Size(), nil
+}
+
+// Release implements vfs.FileDescriptionImpl.Release.
+func (fd *FD) Release(ctx context.Context) {
+	fd.dev.Close(ctx)
+}
+
+// PRead implements vfs.FileDescriptionImpl.PRead.
+func (fd *FD) PRead(ctx context.Context, dst usermem.IOSequence, offset int64, opts vfs.ReadOptions) (int64, error) {
+	if offset < 0 {
+		return 0, syserror.EINVAL
+	}
+	disk, err := fd.disk(ctx)
+	if disk == nil {
+		return 0, err
+	}
+	defer disk.DecRef(ctx)
+	size := disk.Size()
+	if offset >= size {
+		return 0, io.EOF
+	}
+	dst = dst.TakeFirst64(size - offset)
+	buf := make([]byte, dst.NumBytes())
+	n, err := disk.ReadAt(ctx, buf, offset)
+	if err == io.EOF {
+		err = nil
+	}
+	cn, cerr := dst.CopyOut(ctx, buf[:n])
+	if cerr != nil {
+		return int64(cn), cerr
+	}
+	return int64(cn), err
+}
+
+// Read implements vfs.FileDescriptionImpl.Read.
+func (fd *FD) Read(ctx context.Context, dst usermem.IOSequence, opts vfs.ReadOptions) (int64, error) {
+	fd.mu.Lock()
+	n, err := fd.PRead(ctx, dst, fd.off, opts)
+	fd.off += n
+	fd.mu.Unlock()
+	return n, err
+}
+
+// PWrite implements vfs.FileDescriptionImpl.PWrite.
+func (fd *FD) PWrite(ctx context.Context, src usermem.IOSequence, offset int64, opts vfs.WriteOptions) (int64, error) {
+	if offset < 0 {
+		return 0, syserror.EINVAL
+	}
+	disk, err := fd.disk(ctx)
+	if err != nil {
+		return 0, err
+	}
+	if disk == nil {
+		return 0, syserror.ENOSPC
+	}
+	defer disk.DecRef(ctx)
+	if disk.ReadOnly() {
+		return 0, syserror.EPERM
+	}
+	size := disk.Size()
+	if offset >= size {
+		return 0, syserror.ENOSPC
+	}
+	src = src.TakeFirst64(size - offset)
+	buf := make([]byte, src.NumBytes())
+	cn, cerr := src.CopyIn(ctx, buf)
+	n, err := disk.WriteAt(ctx, buf[:cn], offset)
+	if err == nil {
+		err = cerr
+	}
+	return int64(n), err
+}
+
+// Write implements vfs.FileDescriptionImpl.Write.
+func (fd *FD) Write(ctx context.Context, src usermem.IOSequence, opts vfs.WriteOptions) (int64, error) {
+	fd.mu.Lock()
+	n, err := fd.PWrite(ctx, src, fd.off, opts)
+	fd.off += n
+	fd.mu.Unlock()
+	return n, err
+}
+
+// Seek implements vfs.FileDescriptionImpl.Seek.
+func (fd *FD) Seek(ctx context.Context, offset int64, whence int32) (int64, error) {
+	fd.mu.Lock()
+	defer fd.mu.Unlock()
+	switch whence {
+	case linux.SEEK_SET:
+		// use offset as specified
+	case linux.SEEK_CUR:
+		offset += fd.off
+	case linux.SEEK_END:
+		disk, err := fd.disk(ctx)
+		if err != nil {
+			return 0, err
+		}
+		if disk != nil {
+			offset += disk.Size()
+			disk.DecRef(ctx)
+		}
+	default:
+		return 0, syserror.EINVAL
+	}
+	if offset < 0 {
+		return 0, syserror.EINVAL
+	}
+	fd.off = offset
+	return offset, nil
+}
+
+// Sync implements vfs.FileDescriptionImpl.Sync.
+func (fd *FD) Sync(ctx context.Context) error {
+	// Disks don't cache writes.
+	return nil
+}
+
+// Ioctl implements vfs.FileDescriptionImpl.Ioctl.
+func (fd *FD) Ioctl(ctx context.Context, uio usermem.IO, args arch.SyscallArguments) (uintptr, error) {
+	request := args[1].Uint()
+	addr := args[2].Pointer()
+
+	t := kernel.TaskFromContext(ctx)
+	if t == nil {
+		panic("Ioctl should be called from a task context")
+	}
+
+	switch request {
+	case linux.BLKGETSIZE64, linux.BLKGETSIZE, linux.BLKROGET:
+		var size int64
+		readOnly := false
+		disk, err := fd.disk(ctx)
+		if err != nil {
+			return 0, err
+		}
+		if disk != nil {
+			size = disk.Size()
+			readOnly = disk.ReadOnly()
+			disk.DecRef(ctx)
+		}
+		switch request {
+		case linux.BLKGETSIZE64:
+			_, err = primitive.CopyUint64Out(t, addr, uint64(size))
+		case linux.BLKGETSIZE:
+			// The size is in sectors, as an unsigned long.
+			_, err = primitive.CopyUint64Out(t, addr, uint64(size/SectorSize))
+		case linux.BLKROGET:
+			var ro int32
+			if readOnly {
+				ro = 1
+			}
+			_, err = primitive.CopyInt32Out(t, addr, ro)
+		}
+		return 0, err
+
+	case linux.BLKSSZGET:
+		_, err := primitive.CopyInt32Out(t, addr, SectorSize)
+		return 0, err
+
+	case linux.BLKBSZGET:
+		_, err := primitive.CopyInt32Out(t, addr, usermem.PageSize)
+		return 0, err
+
+	case linux.BLKFLSBUF:
+		// There are no buffers to flush.
+		return 0, nil
+
+	default:
+		return fd.dev.Ioctl(ctx, fd, uio, args)
+	}
+}
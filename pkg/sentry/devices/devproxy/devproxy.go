@@ -0,0 +1,204 @@
+// Copyright 2021 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package devproxy implements character devices whose ioctls are forwarded
+// to the corresponding device of the host, such as accelerators and
+// attestation devices.
+//
+// Each device is described by a Spec, which lists the ioctls that are
+// forwarded. Ioctls whose argument is a flat structure, containing no
+// pointers or file descriptors, are copied between application memory and a
+// sentry buffer based on the direction and size encoded in the request
+// number, so the host never sees application addresses. Other ioctls must
+// provide a handler that translates their arguments. All other ioctls fail
+// with ENOTTY.
+package devproxy
+
+import (
+	"fmt"
+	"sort"
+
+	"golang.org/x/sys/unix"
+	"gvisor.dev/gvisor/pkg/abi/linux"
+	"gvisor.dev/gvisor/pkg/context"
+	"gvisor.dev/gvisor/pkg/log"
+	"gvisor.dev/gvisor/pkg/sentry/arch"
+	"gvisor.dev/gvisor/pkg/sentry/kernel"
+	"gvisor.dev/gvisor/pkg/sentry/vfs"
+	"gvisor.dev/gvisor/pkg/syserror"
+	"gvisor.dev/gvisor/pkg/usermem"
+)
+
+// IoctlHandler handles an ioctl with the given request and argument, issuing
+// it to the host device hostFD as required.
+type IoctlHandler func(t *kernel.Task, hostFD int32, request uint32, arg usermem.Addr) (uintptr, error)
+
+// Ioctl describes how an ioctl is forwarded to the host.
+type Ioctl struct {
+	// Name is the name of the ioctl, for logging.
+	Name string
+
+	// Handler, if not nil, handles the ioctl. Otherwise, the ioctl's
+	// argument must be a flat structure of IOC_SIZE(request) bytes, which is
+	// copied in from the application if IOC_DIR(request) includes
+	// IOC_WRITE, and copied out to the application after the host ioctl
+	// succeeds if it includes IOC_READ.
+	Handler IoctlHandler
+}
+
+// Spec describes a proxied device.
+type Spec struct {
+	// Name is the name of the device, relative to /dev.
+	Name string
+
+	// Ioctls maps the ioctl requests that are forwarded to the host to
+	// their descriptions.
+	Ioctls map[uint32]Ioctl
+}
+
+// Requests returns the ioctl requests that may be issued to the host device,
+// in increasing order.
+func (s *Spec) Requests() []uint32 {
+	requests := make([]uint32, 0, len(s.Ioctls))
+	for request := range s.Ioctls {
+		requests = append(requests, request)
+	}
+	sort.Slice(requests, func(i, j int) bool { return requests[i] < requests[j] })
+	return requests
+}
+
+// device implements vfs.Device for a proxied device.
+//
+// +stateify savable
+type device struct {
+	// name is spec.Name. It is saved so that the device can be identified
+	// in logs after restore.
+	name string
+
+	// spec describes the device. It is nil after restore.
+	spec *Spec `state:"nosave"`
+
+	// hostFD is the corresponding device of the host, or -1 if the device is
+	// unavailable. Host devices are not carried over by save/restore, since
+	// the restored sandbox may run on a different host.
+	hostFD int32 `state:"nosave"`
+}
+
+func (d *device) afterLoad() {
+	d.hostFD = -1
+}
+
+// Open implements vfs.Device.Open.
+func (d *device) Open(ctx context.Context, mnt *vfs.Mount, vfsd *vfs.Dentry, opts vfs.OpenOptions) (*vfs.FileDescription, error) {
+	if d.hostFD < 0 {
+		return nil, syserror.ENODEV
+	}
+	fd := &fileDescription{dev: d}
+	if err := fd.vfsfd.Init(fd, opts.Flags, mnt, vfsd, &vfs.FileDescriptionOptions{
+		UseDentryMetadata: true,
+	}); err != nil {
+		return nil, err
+	}
+	return &fd.vfsfd, nil
+}
+
+// fileDescription implements vfs.FileDescriptionImpl for proxied devices.
+//
+// +stateify savable
+type fileDescription struct {
+	vfsfd vfs.FileDescription
+	vfs.FileDescriptionDefaultImpl
+	vfs.DentryMetadataFileDescriptionImpl
+	vfs.NoLockFD
+
+	dev *device
+}
+
+// Release implements vfs.FileDescriptionImpl.Release.
+func (fd *fileDescription) Release(context.Context) {}
+
+// Ioctl implements vfs.FileDescriptionImpl.Ioctl.
+func (fd *fileDescription) Ioctl(ctx context.Context, uio usermem.IO, args arch.SyscallArguments) (uintptr, error) {
+	t := kernel.TaskFromContext(ctx)
+	if t == nil {
+		panic("Ioctl should be called from a task context")
+	}
+	d := fd.dev
+	if d.hostFD < 0 {
+		return 0, syserror.ENODEV
+	}
+	request := args[1].Uint()
+	arg := args[2].Pointer()
+	ioctl, ok := d.spec.Ioctls[request]
+	if !ok {
+		t.Debugf("Unsupported ioctl %#x on %s", request, d.name)
+		return 0, syserror.ENOTTY
+	}
+	if ioctl.Handler != nil {
+		return ioctl.Handler(t, d.hostFD, request, arg)
+	}
+	return flatIoctl(t, d.hostFD, request, arg)
+}
+
+// flatIoctl forwards an ioctl whose argument is a flat structure.
+func flatIoctl(t *kernel.Task, hostFD int32, request uint32, arg usermem.Addr) (uintptr, error) {
+	dir := linux.IOC_DIR(request)
+	var buf []byte
+	if size := linux.IOC_SIZE(request); size != 0 {
+		buf = make([]byte, size)
+	}
+	if dir&linux.IOC_WRITE != 0 && len(buf) != 0 {
+		if _, err := t.CopyInBytes(arg, buf); err != nil {
+			return 0, err
+		}
+	}
+	n, err := HostIoctlBytes(hostFD, request, buf)
+	if err != nil {
+		return 0, err
+	}
+	if dir&linux.IOC_READ != 0 && len(buf) != 0 {
+		if _, err := t.CopyOutBytes(arg, buf); err != nil {
+			return 0, err
+		}
+	}
+	return n, nil
+}
+
+// Register registers the device described by spec in vfsObj, with the same
+// device number as hostFD. Requests to the device are forwarded to the host
+// device hostFD, which must remain open for the lifetime of vfsObj.
+func Register(vfsObj *vfs.VirtualFilesystem, spec *Spec, hostFD int) error {
+	var stat unix.Stat_t
+	if err := unix.Fstat(hostFD, &stat); err != nil {
+		return fmt.Errorf("stat host %s: %w", spec.Name, err)
+	}
+	if stat.Mode&unix.S_IFMT != unix.S_IFCHR {
+		return fmt.Errorf("host %s is not a character device", spec.Name)
+	}
+	for request, ioctl := range spec.Ioctls {
+		if ioctl.Handler == nil && linux.IOC_DIR(request) != linux.IOC_NONE && linux.IOC_SIZE(request) == 0 {
+			return fmt.Errorf("%s ioctl %s (%#x) has no handler and no argument size", spec.Name, ioctl.Name, request)
+		}
+	}
+	dev := &device{
+		name:   spec.Name,
+		spec:   spec,
+		hostFD: int32(hostFD),
+	}
+	log.Infof("Proxying %s to host device %d:%d", spec.Name, unix.Major(stat.Rdev), unix.Minor(stat.Rdev))
+	return vfsObj.RegisterDevice(vfs.CharDevice, unix.Major(stat.Rdev), unix.Minor(stat.Rdev), dev, &vfs.RegisterDeviceOptions{
+		Pathname:  spec.Name,
+		FilePerms: 0600,
+	})
+}
@@ -0,0 +1,138 @@
+// Copyright 2021 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package devproxy
+
+import (
+	"io/ioutil"
+	"os"
+	"reflect"
+	"testing"
+
+	"gvisor.dev/gvisor/pkg/abi/linux"
+	"gvisor.dev/gvisor/pkg/sentry/contexttest"
+	"gvisor.dev/gvisor/pkg/sentry/kernel"
+	"gvisor.dev/gvisor/pkg/sentry/vfs"
+	"gvisor.dev/gvisor/pkg/usermem"
+)
+
+func noHandler(*kernel.Task, int32, uint32, usermem.Addr) (uintptr, error) {
+	return 0, nil
+}
+
+func TestRequests(t *testing.T) {
+	spec := Spec{
+		Name: "test",
+		Ioctls: map[uint32]Ioctl{
+			0xc0104b23: {Name: "c"},
+			0x80084b01: {Name: "a"},
+			0xc0284b05: {Name: "d"},
+			0x00004b02: {Name: "b", Handler: noHandler},
+		},
+	}
+	want := []uint32{0x00004b02, 0x80084b01, 0xc0104b23, 0xc0284b05}
+	if got := spec.Requests(); !reflect.DeepEqual(got, want) {
+		t.Errorf("Requests() got %#x, want %#x", got, want)
+	}
+	if got := (&Spec{Name: "empty"}).Requests(); len(got) != 0 {
+		t.Errorf("Requests() of a spec with no ioctls got %#x, want none", got)
+	}
+}
+
+func TestRegister(t *testing.T) {
+	ctx := contexttest.Context(t)
+	flat := linux.IOC(linux.IOC_READ|linux.IOC_WRITE, 'X', 1, 16)
+	noSize := linux.IOC(linux.IOC_READ, 'X', 2, 0)
+	noArg := linux.IOC(linux.IOC_NONE, 'X', 3, 0)
+	for _, test := range []struct {
+		name    string
+		path    string
+		ioctls  map[uint32]Ioctl
+		wantErr bool
+	}{
+		{
+			name: "flat and handled ioctls",
+			path: "/dev/null",
+			ioctls: map[uint32]Ioctl{
+				flat:   {Name: "FLAT"},
+				noSize: {Name: "HANDLED", Handler: noHandler},
+				noArg:  {Name: "NOARG"},
+			},
+		},
+		{
+			// The size of the argument of a flat ioctl must be known.
+			name: "flat ioctl without size",
+			path: "/dev/null",
+			ioctls: map[uint32]Ioctl{
+				noSize: {Name: "NOSIZE"},
+			},
+			wantErr: true,
+		},
+		{
+			name: "not a character device",
+			ioctls: map[uint32]Ioctl{
+				flat: {Name: "FLAT"},
+			},
+			wantErr: true,
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			path := test.path
+			if path == "" {
+				f, err := ioutil.TempFile("", "devproxy")
+				if err != nil {
+					t.Fatalf("TempFile failed: %v", err)
+				}
+				f.Close()
+				defer os.Remove(f.Name())
+				path = f.Name()
+			}
+			f, err := os.Open(path)
+			if err != nil {
+				t.Fatalf("Open(%q) failed: %v", path, err)
+			}
+			defer f.Close()
+
+			vfsObj := &vfs.VirtualFilesystem{}
+			if err := vfsObj.Init(ctx); err != nil {
+				t.Fatalf("VFS init: %v", err)
+			}
+			spec := &Spec{Name: "test", Ioctls: test.ioctls}
+			err = Register(vfsObj, spec, int(f.Fd()))
+			if test.wantErr {
+				if err == nil {
+					t.Errorf("Register succeeded, want error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Register failed: %v", err)
+			}
+
+			// The device has the number of the host device, /dev/null.
+			var found bool
+			vfsObj.ForEachDevice(func(kind vfs.DeviceKind, major, minor uint32, opts *vfs.RegisterDeviceOptions) error {
+				if kind == vfs.CharDevice && major == 1 && minor == 3 {
+					found = true
+					if opts.Pathname != "test" || opts.FilePerms != 0600 {
+						t.Errorf("device registered with options %+v, want pathname test and permissions 0600", *opts)
+					}
+				}
+				return nil
+			})
+			if !found {
+				t.Errorf("device 1:3 not registered")
+			}
+		})
+	}
+}
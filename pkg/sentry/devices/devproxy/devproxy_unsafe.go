@@ -0,0 +1,45 @@
+// Copyright 2021 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package devproxy
+
+import (
+	"runtime"
+	"syscall"
+	"unsafe"
+)
+
+// HostIoctl issues the given ioctl to the host device hostFD. arg must not
+// point to application memory.
+func HostIoctl(hostFD int32, request uint32, arg unsafe.Pointer) (uintptr, error) {
+	// Device ioctls may block while the host driver waits for hardware, so
+	// use Syscall rather than RawSyscall.
+	n, _, errno := syscall.Syscall(syscall.SYS_IOCTL, uintptr(hostFD), uintptr(request), uintptr(arg))
+	if errno != 0 {
+		return 0, errno
+	}
+	return n, nil
+}
+
+// HostIoctlBytes issues the given ioctl to the host device hostFD, with buf
+// as its argument.
+func HostIoctlBytes(hostFD int32, request uint32, buf []byte) (uintptr, error) {
+	var arg unsafe.Pointer
+	if len(buf) != 0 {
+		arg = unsafe.Pointer(&buf[0])
+	}
+	n, err := HostIoctl(hostFD, request, arg)
+	runtime.KeepAlive(buf)
+	return n, err
+}
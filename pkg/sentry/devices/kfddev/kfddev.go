@@ -0,0 +1,49 @@
+// Copyright 2021 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package kfddev implements /dev/kfd, the AMD ROCm compute device, by
+// proxying it to the host's device.
+//
+// Only ioctls that query the driver and its GPUs are supported so far. Queue
+// creation, memory allocation and mapping of device memory into application
+// address spaces are not, so GPU compute is not yet possible.
+package kfddev
+
+import (
+	"gvisor.dev/gvisor/pkg/abi/linux"
+	"gvisor.dev/gvisor/pkg/sentry/devices/devproxy"
+	"gvisor.dev/gvisor/pkg/sentry/vfs"
+)
+
+// Name is the name of the device, relative to /dev.
+const Name = "kfd"
+
+// Spec returns the specification of /dev/kfd.
+func Spec() *devproxy.Spec {
+	return &devproxy.Spec{
+		Name: Name,
+		Ioctls: map[uint32]devproxy.Ioctl{
+			linux.AMDKFD_IOC_GET_VERSION:        {Name: "AMDKFD_IOC_GET_VERSION"},
+			linux.AMDKFD_IOC_GET_CLOCK_COUNTERS: {Name: "AMDKFD_IOC_GET_CLOCK_COUNTERS"},
+			linux.AMDKFD_IOC_AVAILABLE_MEMORY:   {Name: "AMDKFD_IOC_AVAILABLE_MEMORY"},
+		},
+	}
+}
+
+// Register registers /dev/kfd in vfsObj. Requests to the device are
+// forwarded to the host's /dev/kfd, hostFD, which must remain open for the
+// lifetime of vfsObj.
+func Register(vfsObj *vfs.VirtualFilesystem, hostFD int) error {
+	return devproxy.Register(vfsObj, Spec(), hostFD)
+}
@@ -0,0 +1,49 @@
+// Copyright 2021 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package kfddev
+
+import (
+	"reflect"
+	"testing"
+
+	"gvisor.dev/gvisor/pkg/abi/linux"
+)
+
+func TestSpec(t *testing.T) {
+	spec := Spec()
+	if spec.Name != Name {
+		t.Errorf("got name %q, want %q", spec.Name, Name)
+	}
+
+	// These ioctls and no others may be issued to the host device. The
+	// request numbers are those of the Linux headers.
+	want := []uint32{
+		0x80084b01, // AMDKFD_IOC_GET_VERSION
+		0xc0104b23, // AMDKFD_IOC_AVAILABLE_MEMORY
+		0xc0284b05, // AMDKFD_IOC_GET_CLOCK_COUNTERS
+	}
+	if got := spec.Requests(); !reflect.DeepEqual(got, want) {
+		t.Errorf("Requests() got %#x, want %#x", got, want)
+	}
+
+	// All of them are flat, so their argument sizes must be known.
+	for request, ioctl := range spec.Ioctls {
+		if ioctl.Handler != nil {
+			continue
+		}
+		if linux.IOC_DIR(request) == linux.IOC_NONE || linux.IOC_SIZE(request) == 0 {
+			t.Errorf("flat ioctl %s (%#x) has no argument", ioctl.Name, request)
+		}
+	}
+}
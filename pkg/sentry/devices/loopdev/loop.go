@@ -0,0 +1,543 @@
+// Copyright 2021 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package loopdev
+
+import (
+	"io"
+
+	"gvisor.dev/gvisor/pkg/abi/linux"
+	"gvisor.dev/gvisor/pkg/context"
+	"gvisor.dev/gvisor/pkg/sentry/arch"
+	"gvisor.dev/gvisor/pkg/sentry/devices/blockdev"
+	"gvisor.dev/gvisor/pkg/sentry/kernel"
+	"gvisor.dev/gvisor/pkg/sentry/vfs"
+	"gvisor.dev/gvisor/pkg/sync"
+	"gvisor.dev/gvisor/pkg/syserror"
+	"gvisor.dev/gvisor/pkg/usermem"
+)
+
+// loopDevice implements blockdev.Device for /dev/loopN.
+//
+// +stateify savable
+type loopDevice struct {
+	ctl *loopControl
+
+	// number is the device number. number is immutable.
+	number uint32
+
+	// mu protects the fields below, and the mutable fields of disk.
+	mu sync.Mutex `state:"nosave"`
+
+	// users is the number of FDs representing the device and of references
+	// on disk returned by Disk.
+	users int
+
+	// removed is true if the device was removed by LOOP_CTL_REMOVE.
+	removed bool
+
+	// disk is the file bound to the device, or nil if the device is unbound.
+	disk *loopDisk
+
+	// flags are the LO_FLAGS_* of the device, excluding LO_FLAGS_READ_ONLY,
+	// which is disk.readOnly.
+	flags uint32
+
+	// blockSize is the logical block size set by LOOP_CONFIGURE or
+	// LOOP_SET_BLOCK_SIZE. It is informational only, since disks are
+	// accessed at byte granularity.
+	blockSize uint32
+
+	// fileName and cryptName are set by LOOP_SET_STATUS64 and returned by
+	// LOOP_GET_STATUS64.
+	fileName  [linux.LO_NAME_SIZE]byte
+	cryptName [linux.LO_NAME_SIZE]byte
+}
+
+// loopDisk implements vfs.Disk for the file bound to a loop device.
+//
+// +stateify savable
+type loopDisk struct {
+	dev *loopDevice
+
+	// file is the bound file. The disk holds a reference on it. file is
+	// protected by dev.mu.
+	file *vfs.FileDescription
+
+	// offset is the offset in file of the start of the disk, and sizeLimit
+	// is the maximum size of the disk, or 0 if it extends to the end of
+	// file. size is the size of the disk. These fields are protected by
+	// dev.mu.
+	offset    int64
+	sizeLimit int64
+	size      int64
+
+	// readOnly is true if the disk can't be written. readOnly is immutable.
+	readOnly bool
+}
+
+// Open implements vfs.Device.Open.
+func (dev *loopDevice) Open(ctx context.Context, mnt *vfs.Mount, vfsd *vfs.Dentry, opts vfs.OpenOptions) (*vfs.FileDescription, error) {
+	dev.mu.Lock()
+	if dev.removed {
+		dev.mu.Unlock()
+		return nil, syserror.ENXIO
+	}
+	dev.users++
+	dev.mu.Unlock()
+	fd, err := blockdev.NewFD(dev, mnt, vfsd, opts.Flags)
+	if err != nil {
+		dev.Close(ctx)
+		return nil, err
+	}
+	return fd, nil
+}
+
+// Disk implements vfs.DiskDevice.Disk.
+func (dev *loopDevice) Disk(ctx context.Context) (vfs.Disk, error) {
+	dev.mu.Lock()
+	defer dev.mu.Unlock()
+	if dev.disk == nil {
+		return nil, syserror.ENXIO
+	}
+	dev.users++
+	return dev.disk, nil
+}
+
+// Close implements blockdev.Device.Close.
+func (dev *loopDevice) Close(ctx context.Context) {
+	dev.mu.Lock()
+	dev.users--
+	var file *vfs.FileDescription
+	if dev.users == 0 && dev.disk != nil && dev.flags&linux.LO_FLAGS_AUTOCLEAR != 0 {
+		file = dev.unbindLocked()
+	}
+	dev.mu.Unlock()
+	if file != nil {
+		file.DecRef(ctx)
+	}
+}
+
+// unbindLocked unbinds the file bound to dev, and returns it. The caller must
+// drop the reference on it that was held by the disk.
+//
+// Preconditions: dev.mu must be locked. dev.disk != nil. No references on
+// dev.disk are held.
+func (dev *loopDevice) unbindLocked() *vfs.FileDescription {
+	file := dev.disk.file
+	dev.disk = nil
+	dev.flags = 0
+	dev.blockSize = 0
+	dev.fileName = [linux.LO_NAME_SIZE]byte{}
+	dev.cryptName = [linux.LO_NAME_SIZE]byte{}
+	return file
+}
+
+// fileSize returns the size of file, which must be a regular file or a block
+// device.
+func fileSize(ctx context.Context, file *vfs.FileDescription) (int64, error) {
+	if bfd, ok := file.Impl().(*blockdev.FD); ok {
+		return bfd.Size(ctx)
+	}
+	stat, err := file.Stat(ctx, vfs.StatOptions{Mask: linux.STATX_SIZE})
+	if err != nil {
+		return 0, err
+	}
+	return int64(stat.Size), nil
+}
+
+// updateSizeLocked updates the size of disk from the size of the bound file.
+//
+// Preconditions: disk.dev.mu must be locked.
+func (disk *loopDisk) updateSizeLocked(ctx context.Context) error {
+	size, err := fileSize(ctx, disk.file)
+	if err != nil {
+		return err
+	}
+	size -= disk.offset
+	if size < 0 {
+		size = 0
+	}
+	if disk.sizeLimit > 0 && disk.sizeLimit < size {
+		size = disk.sizeLimit
+	}
+	// Disks consist of whole sectors.
+	disk.size = size &^ (blockdev.SectorSize - 1)
+	return nil
+}
+
+// bind binds the file with FD backingFD in t's FD table to dev. fd is the FD
+// of dev used to bind it. If info is not nil, it configures the device as for
+// LOOP_SET_STATUS64.
+func (dev *loopDevice) bind(ctx context.Context, t *kernel.Task, fd *blockdev.FD, backingFD int32, info *linux.LoopInfo64, blockSize uint32) error {
+	file := t.GetFileVFS2(backingFD)
+	if file == nil {
+		return syserror.EBADF
+	}
+	stat, err := file.Stat(ctx, vfs.StatOptions{Mask: linux.STATX_TYPE})
+	if err != nil {
+		file.DecRef(ctx)
+		return err
+	}
+	if mode := stat.Mode & linux.S_IFMT; mode != linux.S_IFREG && mode != linux.S_IFBLK {
+		file.DecRef(ctx)
+		return syserror.EINVAL
+	}
+	if bfd, ok := file.Impl().(*blockdev.FD); ok && bfd.VFSFileDescription() == fd.VFSFileDescription() {
+		// Loop devices can't be bound to themselves.
+		file.DecRef(ctx)
+		return syserror.EINVAL
+	}
+
+	// Loop devices are read-only if the bound file or the device was opened
+	// read-only, see Linux's drivers/block/loop.c:loop_configure().
+	readOnly := !file.IsWritable() || !fd.VFSFileDescription().IsWritable()
+	if info != nil && info.Flags&linux.LO_FLAGS_READ_ONLY != 0 {
+		readOnly = true
+	}
+	disk := &loopDisk{
+		dev:      dev,
+		file:     file,
+		readOnly: readOnly,
+	}
+
+	dev.mu.Lock()
+	defer dev.mu.Unlock()
+	if dev.disk != nil {
+		file.DecRef(ctx)
+		return syserror.EBUSY
+	}
+	if info != nil {
+		if err := dev.setStatusLocked(disk, info); err != nil {
+			file.DecRef(ctx)
+			return err
+		}
+		dev.flags = info.Flags &^ linux.LO_FLAGS_READ_ONLY
+	}
+	if err := disk.updateSizeLocked(ctx); err != nil {
+		file.DecRef(ctx)
+		return err
+	}
+	dev.disk = disk
+	dev.blockSize = blockSize
+	return nil
+}
+
+// setStatusLocked applies the parts of info that are common to
+// LOOP_CONFIGURE and LOOP_SET_STATUS64 to disk. Flags are left to the caller.
+//
+// Preconditions: dev.mu must be locked.
+func (dev *loopDevice) setStatusLocked(disk *loopDisk, info *linux.LoopInfo64) error {
+	// Encryption isn't supported, like in Linux since 5.x.
+	if info.EncryptType != 0 || info.EncryptKeySize != 0 {
+		return syserror.EINVAL
+	}
+	if int64(info.Offset) < 0 || int64(info.SizeLimit) < 0 {
+		return syserror.EINVAL
+	}
+	disk.offset = int64(info.Offset)
+	disk.sizeLimit = int64(info.SizeLimit)
+	dev.fileName = info.FileName
+	dev.cryptName = info.CryptName
+	return nil
+}
+
+// getStatus returns the status of dev, as for LOOP_GET_STATUS64.
+func (dev *loopDevice) getStatus(ctx context.Context) (linux.LoopInfo64, error) {
+	dev.mu.Lock()
+	defer dev.mu.Unlock()
+	if dev.disk == nil {
+		return linux.LoopInfo64{}, syserror.ENXIO
+	}
+	stat, err := dev.disk.file.Stat(ctx, vfs.StatOptions{Mask: linux.STATX_INO})
+	if err != nil {
+		return linux.LoopInfo64{}, err
+	}
+	info := linux.LoopInfo64{
+		Device:    uint64(linux.MakeDeviceID(uint16(stat.DevMajor), stat.DevMinor)),
+		Inode:     stat.Ino,
+		Rdevice:   uint64(linux.MakeDeviceID(uint16(stat.RdevMajor), stat.RdevMinor)),
+		Offset:    uint64(dev.disk.offset),
+		SizeLimit: uint64(dev.disk.sizeLimit),
+		Number:    dev.number,
+		Flags:     dev.flags,
+		FileName:  dev.fileName,
+		CryptName: dev.cryptName,
+	}
+	if dev.disk.readOnly {
+		info.Flags |= linux.LO_FLAGS_READ_ONLY
+	}
+	return info, nil
+}
+
+// validBlockSize returns true if size is a valid logical block size. 0
+// selects the default block size.
+func validBlockSize(size uint32) bool {
+	return size == 0 || (size >= blockdev.SectorSize && size <= usermem.PageSize && size&(size-1) == 0)
+}
+
+// Ioctl implements blockdev.Device.Ioctl.
+func (dev *loopDevice) Ioctl(ctx context.Context, fd *blockdev.FD, uio usermem.IO, args arch.SyscallArguments) (uintptr, error) {
+	request := args[1].Uint()
+	addr := args[2].Pointer()
+
+	t := kernel.TaskFromContext(ctx)
+	if t == nil {
+		panic("Ioctl should be called from a task context")
+	}
+
+	switch request {
+	case linux.LOOP_CLR_FD, linux.LOOP_SET_STATUS64, linux.LOOP_SET_CAPACITY, linux.LOOP_SET_DIRECT_IO, linux.LOOP_SET_BLOCK_SIZE:
+		// Changing the configuration of bound devices requires write access
+		// to the device, see Linux's drivers/block/loop.c:lo_ioctl().
+		if !fd.VFSFileDescription().IsWritable() && !t.HasCapability(linux.CAP_SYS_ADMIN) {
+			return 0, syserror.EPERM
+		}
+	}
+
+	switch request {
+	case linux.LOOP_SET_FD:
+		// The FD is passed by value.
+		return 0, dev.bind(ctx, t, fd, args[2].Int(), nil, 0)
+
+	case linux.LOOP_CONFIGURE:
+		var config linux.LoopConfig
+		if _, err := config.CopyIn(t, addr); err != nil {
+			return 0, err
+		}
+		if config.Info.Flags&^linux.LOOP_CONFIGURE_SETTABLE_FLAGS != 0 || !validBlockSize(config.BlockSize) {
+			return 0, syserror.EINVAL
+		}
+		return 0, dev.bind(ctx, t, fd, int32(config.FD), &config.Info, config.BlockSize)
+
+	case linux.LOOP_CHANGE_FD:
+		return 0, dev.changeFD(ctx, t, args[2].Int())
+
+	case linux.LOOP_CLR_FD:
+		dev.mu.Lock()
+		if dev.disk == nil {
+			dev.mu.Unlock()
+			return 0, syserror.ENXIO
+		}
+		if dev.users > 1 {
+			// The device is still in use, e.g. mounted. Unbind it once it
+			// isn't, see Linux's drivers/block/loop.c:loop_clr_fd().
+			dev.flags |= linux.LO_FLAGS_AUTOCLEAR
+			dev.mu.Unlock()
+			return 0, nil
+		}
+		file := dev.unbindLocked()
+		dev.mu.Unlock()
+		file.DecRef(ctx)
+		return 0, nil
+
+	case linux.LOOP_SET_STATUS64:
+		var info linux.LoopInfo64
+		if _, err := info.CopyIn(t, addr); err != nil {
+			return 0, err
+		}
+		dev.mu.Lock()
+		defer dev.mu.Unlock()
+		if dev.disk == nil {
+			return 0, syserror.ENXIO
+		}
+		if err := dev.setStatusLocked(dev.disk, &info); err != nil {
+			return 0, err
+		}
+		const settable = linux.LOOP_SET_STATUS_SETTABLE_FLAGS
+		dev.flags = (dev.flags &^ settable) | (info.Flags & settable)
+		return 0, dev.disk.updateSizeLocked(ctx)
+
+	case linux.LOOP_GET_STATUS64:
+		info, err := dev.getStatus(ctx)
+		if err != nil {
+			return 0, err
+		}
+		_, err = info.CopyOut(t, addr)
+		return 0, err
+
+	case linux.LOOP_SET_CAPACITY:
+		dev.mu.Lock()
+		defer dev.mu.Unlock()
+		if dev.disk == nil {
+			return 0, syserror.ENXIO
+		}
+		return 0, dev.disk.updateSizeLocked(ctx)
+
+	case linux.LOOP_SET_DIRECT_IO:
+		dev.mu.Lock()
+		defer dev.mu.Unlock()
+		if dev.disk == nil {
+			return 0, syserror.ENXIO
+		}
+		// Disks don't cache data, so this only changes the reported flags.
+		if args[2].Uint64() != 0 {
+			dev.flags |= linux.LO_FLAGS_DIRECT_IO
+		} else {
+			dev.flags &^= linux.LO_FLAGS_DIRECT_IO
+		}
+		return 0, nil
+
+	case linux.LOOP_SET_BLOCK_SIZE:
+		size := args[2].Uint()
+		if !validBlockSize(size) {
+			return 0, syserror.EINVAL
+		}
+		dev.mu.Lock()
+		defer dev.mu.Unlock()
+		if dev.disk == nil {
+			return 0, syserror.ENXIO
+		}
+		dev.blockSize = size
+		return 0, nil
+
+	default:
+		return 0, syserror.ENOTTY
+	}
+}
+
+// changeFD replaces the file bound to dev, which must be read-only, with the
+// file with FD backingFD in t's FD table, which must have the same size. See
+// Linux's drivers/block/loop.c:loop_change_fd().
+func (dev *loopDevice) changeFD(ctx context.Context, t *kernel.Task, backingFD int32) error {
+	file := t.GetFileVFS2(backingFD)
+	if file == nil {
+		return syserror.EBADF
+	}
+	stat, err := file.Stat(ctx, vfs.StatOptions{Mask: linux.STATX_TYPE})
+	if err != nil {
+		file.DecRef(ctx)
+		return err
+	}
+	if mode := stat.Mode & linux.S_IFMT; mode != linux.S_IFREG && mode != linux.S_IFBLK {
+		file.DecRef(ctx)
+		return syserror.EINVAL
+	}
+	newSize, err := fileSize(ctx, file)
+	if err != nil {
+		file.DecRef(ctx)
+		return err
+	}
+
+	dev.mu.Lock()
+	if dev.disk == nil {
+		dev.mu.Unlock()
+		file.DecRef(ctx)
+		return syserror.ENXIO
+	}
+	if !dev.disk.readOnly {
+		dev.mu.Unlock()
+		file.DecRef(ctx)
+		return syserror.EINVAL
+	}
+	oldSize, err := fileSize(ctx, dev.disk.file)
+	if err != nil || oldSize != newSize {
+		dev.mu.Unlock()
+		file.DecRef(ctx)
+		if err == nil {
+			err = syserror.EINVAL
+		}
+		return err
+	}
+	oldFile := dev.disk.file
+	dev.disk.file = file
+	dev.mu.Unlock()
+	oldFile.DecRef(ctx)
+	return nil
+}
+
+// ReadAt implements vfs.Disk.ReadAt.
+func (disk *loopDisk) ReadAt(ctx context.Context, p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, syserror.EINVAL
+	}
+	disk.dev.mu.Lock()
+	file, offset, size := disk.file, disk.offset, disk.size
+	file.IncRef()
+	disk.dev.mu.Unlock()
+	defer file.DecRef(ctx)
+
+	if off >= size {
+		return 0, io.EOF
+	}
+	var err error
+	if rem := size - off; int64(len(p)) > rem {
+		p = p[:rem]
+		err = io.EOF
+	}
+	var done int
+	for done < len(p) {
+		n, rerr := file.PRead(ctx, usermem.BytesIOSequence(p[done:]), offset+off+int64(done), vfs.ReadOptions{})
+		done += int(n)
+		if rerr != nil {
+			return done, rerr
+		}
+		if n == 0 {
+			// The file is shorter than the disk, e.g. because it was
+			// truncated since the disk's size was last updated.
+			return done, io.EOF
+		}
+	}
+	return done, err
+}
+
+// WriteAt implements vfs.Disk.WriteAt.
+func (disk *loopDisk) WriteAt(ctx context.Context, p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, syserror.EINVAL
+	}
+	if disk.readOnly {
+		return 0, syserror.EPERM
+	}
+	disk.dev.mu.Lock()
+	file, offset, size := disk.file, disk.offset, disk.size
+	file.IncRef()
+	disk.dev.mu.Unlock()
+	defer file.DecRef(ctx)
+
+	if off >= size {
+		return 0, syserror.ENOSPC
+	}
+	var err error
+	if rem := size - off; int64(len(p)) > rem {
+		p = p[:rem]
+		err = syserror.ENOSPC
+	}
+	var done int
+	for done < len(p) {
+		n, werr := file.PWrite(ctx, usermem.BytesIOSequence(p[done:]), offset+off+int64(done), vfs.WriteOptions{})
+		done += int(n)
+		if werr != nil {
+			return done, werr
+		}
+	}
+	return done, err
+}
+
+// Size implements vfs.Disk.Size.
+func (disk *loopDisk) Size() int64 {
+	disk.dev.mu.Lock()
+	defer disk.dev.mu.Unlock()
+	return disk.size
+}
+
+// ReadOnly implements vfs.Disk.ReadOnly.
+func (disk *loopDisk) ReadOnly() bool {
+	return disk.readOnly
+}
+
+// DecRef implements vfs.Disk.DecRef.
+func (disk *loopDisk) DecRef(ctx context.Context) {
+	disk.dev.Close(ctx)
+}
@@ -0,0 +1,231 @@
+// Copyright 2021 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package loopdev
+
+import (
+	"bytes"
+	"testing"
+
+	"gvisor.dev/gvisor/pkg/abi/linux"
+	"gvisor.dev/gvisor/pkg/sentry/arch"
+	"gvisor.dev/gvisor/pkg/sentry/devices/blockdev"
+	"gvisor.dev/gvisor/pkg/sentry/fsimpl/testutil"
+	"gvisor.dev/gvisor/pkg/sentry/fsimpl/tmpfs"
+	"gvisor.dev/gvisor/pkg/sentry/kernel"
+	"gvisor.dev/gvisor/pkg/sentry/kernel/auth"
+	"gvisor.dev/gvisor/pkg/sentry/memmap"
+	"gvisor.dev/gvisor/pkg/sentry/vfs"
+	"gvisor.dev/gvisor/pkg/syserror"
+	"gvisor.dev/gvisor/pkg/usermem"
+)
+
+// testDiskSize is the size of the backing files of test loop devices.
+const testDiskSize = 4 * blockdev.SectorSize
+
+// loopTest holds a task, loop0 opened read-write and a backing file with FD
+// backingFD in the task's FD table.
+type loopTest struct {
+	s         *testutil.System
+	task      *kernel.Task
+	loop      *vfs.FileDescription
+	backing   *vfs.FileDescription
+	backingFD int32
+	data      []byte
+}
+
+func newLoopTest(t *testing.T) *loopTest {
+	t.Helper()
+	k, err := testutil.Boot()
+	if err != nil {
+		t.Fatalf("Failed to create test kernel: %v", err)
+	}
+	ctx := k.SupervisorContext()
+	creds := auth.CredentialsFromContext(ctx)
+	if err := Register(k.VFS()); err != nil {
+		t.Fatalf("Register failed: %v", err)
+	}
+	mns, err := k.VFS().NewMountNamespace(ctx, creds, "", tmpfs.Name, &vfs.MountOptions{})
+	if err != nil {
+		t.Fatalf("Failed to create new mount namespace: %v", err)
+	}
+	s := testutil.NewSystem(ctx, t, k.VFS(), mns)
+	tg := k.NewThreadGroup(nil, k.RootPIDNamespace(), kernel.NewSignalHandlers(), linux.SIGCHLD, k.GlobalInit().Limits())
+	task, err := testutil.CreateTask(ctx, "loop", tg, mns, s.Root, s.Root)
+	if err != nil {
+		t.Fatalf("CreateTask failed: %v", err)
+	}
+
+	if err := s.VFS.MknodAt(ctx, creds, s.PathOpAtRoot("loop0"), &vfs.MknodOptions{
+		Mode:     linux.S_IFBLK | 0600,
+		DevMajor: linux.LOOP_MAJOR,
+		DevMinor: 0,
+	}); err != nil {
+		t.Fatalf("MknodAt failed: %v", err)
+	}
+	loop, err := s.VFS.OpenAt(ctx, creds, s.PathOpAtRoot("loop0"), &vfs.OpenOptions{Flags: linux.O_RDWR})
+	if err != nil {
+		t.Fatalf("OpenAt of loop device failed: %v", err)
+	}
+
+	backing, err := s.VFS.OpenAt(ctx, creds, s.PathOpAtRoot("backing"), &vfs.OpenOptions{
+		Flags: linux.O_RDWR | linux.O_CREAT,
+		Mode:  0644,
+	})
+	if err != nil {
+		t.Fatalf("OpenAt of backing file failed: %v", err)
+	}
+	data := make([]byte, testDiskSize)
+	for i := range data {
+		data[i] = byte(i)
+	}
+	if _, err := backing.Write(ctx, usermem.BytesIOSequence(data), vfs.WriteOptions{}); err != nil {
+		t.Fatalf("Write to backing file failed: %v", err)
+	}
+	backingFD, err := task.NewFDFromVFS2(0, backing, kernel.FDFlags{})
+	if err != nil {
+		t.Fatalf("NewFDFromVFS2 failed: %v", err)
+	}
+
+	lt := &loopTest{
+		s:         s,
+		task:      task,
+		loop:      loop,
+		backing:   backing,
+		backingFD: backingFD,
+		data:      data,
+	}
+	t.Cleanup(lt.destroy)
+	return lt
+}
+
+func (lt *loopTest) destroy() {
+	lt.loop.DecRef(lt.s.Ctx)
+	lt.backing.DecRef(lt.s.Ctx)
+	lt.s.Destroy()
+}
+
+// ioctl calls ioctl on the loop device from the test task.
+func (lt *loopTest) ioctl(request uint32, arg uintptr) error {
+	_, err := lt.loop.Ioctl(lt.task, lt.task.MemoryManager(), arch.SyscallArguments{
+		{},
+		{Value: uintptr(request)},
+		{Value: arg},
+	})
+	return err
+}
+
+// getStatus calls LOOP_GET_STATUS64 on the loop device.
+func (lt *loopTest) getStatus(t *testing.T) (linux.LoopInfo64, error) {
+	t.Helper()
+	addr, err := lt.task.MemoryManager().MMap(lt.task, memmap.MMapOpts{
+		Length:   usermem.PageSize,
+		Private:  true,
+		Perms:    usermem.ReadWrite,
+		MaxPerms: usermem.AnyAccess,
+	})
+	if err != nil {
+		t.Fatalf("MMap failed: %v", err)
+	}
+	var info linux.LoopInfo64
+	if err := lt.ioctl(linux.LOOP_GET_STATUS64, uintptr(addr)); err != nil {
+		return info, err
+	}
+	if _, err := info.CopyIn(lt.task, addr); err != nil {
+		t.Fatalf("CopyIn of status failed: %v", err)
+	}
+	return info, nil
+}
+
+func TestSetFDGetStatusClearFD(t *testing.T) {
+	lt := newLoopTest(t)
+
+	if _, err := lt.getStatus(t); err != syserror.ENXIO {
+		t.Errorf("LOOP_GET_STATUS64 of unbound device got error %v, want %v", err, syserror.ENXIO)
+	}
+
+	if err := lt.ioctl(linux.LOOP_SET_FD, uintptr(lt.backingFD)); err != nil {
+		t.Fatalf("LOOP_SET_FD failed: %v", err)
+	}
+	if err := lt.ioctl(linux.LOOP_SET_FD, uintptr(lt.backingFD)); err != syserror.EBUSY {
+		t.Errorf("LOOP_SET_FD of bound device got error %v, want %v", err, syserror.EBUSY)
+	}
+
+	stat, err := lt.backing.Stat(lt.s.Ctx, vfs.StatOptions{Mask: linux.STATX_INO})
+	if err != nil {
+		t.Fatalf("Stat failed: %v", err)
+	}
+	info, err := lt.getStatus(t)
+	if err != nil {
+		t.Fatalf("LOOP_GET_STATUS64 failed: %v", err)
+	}
+	if info.Number != 0 || info.Inode != stat.Ino || info.Offset != 0 || info.Flags != 0 {
+		t.Errorf("LOOP_GET_STATUS64 got %+v, want number 0, inode %d, offset 0 and no flags", info, stat.Ino)
+	}
+
+	// The device reads the backing file on behalf of the task.
+	buf := make([]byte, testDiskSize)
+	n, err := lt.loop.PRead(lt.task, usermem.BytesIOSequence(buf), 0, vfs.ReadOptions{})
+	if err != nil || n != testDiskSize || !bytes.Equal(buf, lt.data) {
+		t.Errorf("PRead got (%d, %v), want (%d, nil) and the contents of the backing file", n, err, testDiskSize)
+	}
+
+	if err := lt.ioctl(linux.LOOP_CLR_FD, 0); err != nil {
+		t.Fatalf("LOOP_CLR_FD failed: %v", err)
+	}
+	if _, err := lt.getStatus(t); err != syserror.ENXIO {
+		t.Errorf("LOOP_GET_STATUS64 of cleared device got error %v, want %v", err, syserror.ENXIO)
+	}
+	if err := lt.ioctl(linux.LOOP_CLR_FD, 0); err != syserror.ENXIO {
+		t.Errorf("LOOP_CLR_FD of cleared device got error %v, want %v", err, syserror.ENXIO)
+	}
+}
+
+func TestClearFDInUse(t *testing.T) {
+	lt := newLoopTest(t)
+	if err := lt.ioctl(linux.LOOP_SET_FD, uintptr(lt.backingFD)); err != nil {
+		t.Fatalf("LOOP_SET_FD failed: %v", err)
+	}
+
+	// Hold the disk, as a mounted filesystem does.
+	disk, err := lt.s.VFS.GetDisk(lt.task, linux.LOOP_MAJOR, 0)
+	if err != nil {
+		t.Fatalf("GetDisk failed: %v", err)
+	}
+	if err := lt.ioctl(linux.LOOP_CLR_FD, 0); err != nil {
+		t.Fatalf("LOOP_CLR_FD failed: %v", err)
+	}
+	info, err := lt.getStatus(t)
+	if err != nil {
+		t.Fatalf("LOOP_GET_STATUS64 of device in use failed: %v", err)
+	}
+	if info.Flags&linux.LO_FLAGS_AUTOCLEAR == 0 {
+		t.Errorf("LOOP_GET_STATUS64 got flags %#x, want LO_FLAGS_AUTOCLEAR", info.Flags)
+	}
+
+	// Releasing the last user other than the device's FD doesn't unbind
+	// it; closing the FD does.
+	disk.DecRef(lt.task)
+	if _, err := lt.getStatus(t); err != nil {
+		t.Errorf("LOOP_GET_STATUS64 of open device failed: %v", err)
+	}
+	lt.loop.DecRef(lt.task)
+	lt.loop, err = lt.s.VFS.OpenAt(lt.s.Ctx, lt.s.Creds, lt.s.PathOpAtRoot("loop0"), &vfs.OpenOptions{Flags: linux.O_RDONLY})
+	if err != nil {
+		t.Fatalf("OpenAt of loop device failed: %v", err)
+	}
+	if _, err := lt.getStatus(t); err != syserror.ENXIO {
+		t.Errorf("LOOP_GET_STATUS64 of released device got error %v, want %v", err, syserror.ENXIO)
+	}
+}
@@ -0,0 +1,254 @@
+// Copyright 2021 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package loopdev implements loop block devices and /dev/loop-control, as
+// implemented in Linux by drivers/block/loop.c.
+//
+// Loop devices expose a file as a block device, from which filesystems can be
+// mounted.
+package loopdev
+
+import (
+	"fmt"
+
+	"gvisor.dev/gvisor/pkg/abi/linux"
+	"gvisor.dev/gvisor/pkg/context"
+	"gvisor.dev/gvisor/pkg/sentry/arch"
+	"gvisor.dev/gvisor/pkg/sentry/fsimpl/devtmpfs"
+	"gvisor.dev/gvisor/pkg/sentry/kernel"
+	"gvisor.dev/gvisor/pkg/sentry/kernel/auth"
+	"gvisor.dev/gvisor/pkg/sentry/vfs"
+	"gvisor.dev/gvisor/pkg/sync"
+	"gvisor.dev/gvisor/pkg/syserror"
+	"gvisor.dev/gvisor/pkg/usermem"
+)
+
+const (
+	// initialDevices is the number of loop devices created at boot, as by
+	// Linux's default CONFIG_BLK_DEV_LOOP_MIN_COUNT.
+	initialDevices = 8
+
+	// maxDevices is the maximum number of loop devices, which is bounded by
+	// the range of minor device numbers.
+	maxDevices = 1 << 20
+
+	// devicePerms are the permissions of loop device special files.
+	devicePerms = 0660
+)
+
+// loopControl implements vfs.Device for /dev/loop-control. It tracks all loop
+// devices.
+//
+// +stateify savable
+type loopControl struct {
+	vfsObj *vfs.VirtualFilesystem
+
+	// mu protects devices.
+	mu sync.Mutex `state:"nosave"`
+
+	// devices maps the numbers of loop devices to them. Devices are never
+	// removed from devices, since they can't be unregistered from vfsObj.
+	devices map[uint32]*loopDevice
+}
+
+// Open implements vfs.Device.Open.
+func (ctl *loopControl) Open(ctx context.Context, mnt *vfs.Mount, vfsd *vfs.Dentry, opts vfs.OpenOptions) (*vfs.FileDescription, error) {
+	fd := &controlFD{ctl: ctl}
+	if err := fd.vfsfd.Init(fd, opts.Flags, mnt, vfsd, &vfs.FileDescriptionOptions{
+		UseDentryMetadata: true,
+	}); err != nil {
+		return nil, err
+	}
+	return &fd.vfsfd, nil
+}
+
+// addLocked adds the loop device with the given number. If the device was
+// removed before, it is reused.
+//
+// Preconditions: ctl.mu must be locked.
+func (ctl *loopControl) addLocked(number uint32) (*loopDevice, error) {
+	if dev, ok := ctl.devices[number]; ok {
+		dev.mu.Lock()
+		defer dev.mu.Unlock()
+		if !dev.removed {
+			return nil, syserror.EEXIST
+		}
+		dev.removed = false
+		return dev, nil
+	}
+	dev := &loopDevice{
+		ctl:    ctl,
+		number: number,
+	}
+	if err := ctl.vfsObj.RegisterDevice(vfs.BlockDevice, linux.LOOP_MAJOR, number, dev, &vfs.RegisterDeviceOptions{
+		GroupName: "loop",
+		Pathname:  deviceName(number),
+		FilePerms: devicePerms,
+	}); err != nil {
+		return nil, err
+	}
+	ctl.devices[number] = dev
+	return dev, nil
+}
+
+// createDevtmpfsFile creates the special file of the loop device with the
+// given number in devtmpfs, as Linux's devtmpfs does when devices are added.
+func (ctl *loopControl) createDevtmpfsFile(ctx context.Context, t *kernel.Task, number uint32) error {
+	a, err := devtmpfs.NewAccessor(ctx, ctl.vfsObj, auth.NewRootCredentials(t.Kernel().RootUserNamespace()), devtmpfs.Name)
+	if err != nil {
+		return err
+	}
+	defer a.Release(ctx)
+	if err := a.CreateDeviceFile(ctx, deviceName(number), vfs.BlockDevice, linux.LOOP_MAJOR, number, devicePerms); err != nil && err != syserror.EEXIST {
+		return err
+	}
+	return nil
+}
+
+// deviceName returns the name of the loop device with the given number.
+func deviceName(number uint32) string {
+	return fmt.Sprintf("loop%d", number)
+}
+
+// controlFD implements vfs.FileDescriptionImpl for /dev/loop-control.
+//
+// +stateify savable
+type controlFD struct {
+	vfsfd vfs.FileDescription
+	vfs.FileDescriptionDefaultImpl
+	vfs.DentryMetadataFileDescriptionImpl
+	vfs.NoLockFD
+
+	ctl *loopControl
+}
+
+// Release implements vfs.FileDescriptionImpl.Release.
+func (fd *controlFD) Release(context.Context) {
+	// noop
+}
+
+// Ioctl implements vfs.FileDescriptionImpl.Ioctl.
+func (fd *controlFD) Ioctl(ctx context.Context, uio usermem.IO, args arch.SyscallArguments) (uintptr, error) {
+	request := args[1].Uint()
+	// The device number is passed by value.
+	arg := args[2].Int()
+
+	t := kernel.TaskFromContext(ctx)
+	if t == nil {
+		panic("Ioctl should be called from a task context")
+	}
+
+	ctl := fd.ctl
+	switch request {
+	case linux.LOOP_CTL_ADD:
+		ctl.mu.Lock()
+		var number uint32
+		if arg < 0 {
+			// Pick any unused number.
+			for ; number < maxDevices; number++ {
+				if _, ok := ctl.devices[number]; !ok {
+					break
+				}
+			}
+		} else {
+			number = uint32(arg)
+		}
+		if number >= maxDevices {
+			ctl.mu.Unlock()
+			return 0, syserror.EINVAL
+		}
+		_, err := ctl.addLocked(number)
+		ctl.mu.Unlock()
+		if err != nil {
+			return 0, err
+		}
+		return uintptr(number), ctl.createDevtmpfsFile(ctx, t, number)
+
+	case linux.LOOP_CTL_REMOVE:
+		if arg < 0 {
+			return 0, syserror.EINVAL
+		}
+		ctl.mu.Lock()
+		defer ctl.mu.Unlock()
+		dev, ok := ctl.devices[uint32(arg)]
+		if !ok {
+			return 0, syserror.ENODEV
+		}
+		dev.mu.Lock()
+		defer dev.mu.Unlock()
+		if dev.removed {
+			return 0, syserror.ENODEV
+		}
+		if dev.disk != nil || dev.users != 0 {
+			return 0, syserror.EBUSY
+		}
+		// The special file of the device is left in devtmpfs, like
+		// devtmpfs.Accessor doesn't remove files. Opening it fails with
+		// ENXIO.
+		dev.removed = true
+		return 0, nil
+
+	case linux.LOOP_CTL_GET_FREE:
+		ctl.mu.Lock()
+		var number uint32
+		for ; number < maxDevices; number++ {
+			dev, ok := ctl.devices[number]
+			if !ok {
+				break
+			}
+			dev.mu.Lock()
+			free := !dev.removed && dev.disk == nil
+			dev.mu.Unlock()
+			if free {
+				ctl.mu.Unlock()
+				return uintptr(number), nil
+			}
+		}
+		if number >= maxDevices {
+			ctl.mu.Unlock()
+			return 0, syserror.ENOSPC
+		}
+		_, err := ctl.addLocked(number)
+		ctl.mu.Unlock()
+		if err != nil {
+			return 0, err
+		}
+		return uintptr(number), ctl.createDevtmpfsFile(ctx, t, number)
+
+	default:
+		return 0, syserror.ENOTTY
+	}
+}
+
+// Register registers all devices implemented by this package in vfsObj.
+func Register(vfsObj *vfs.VirtualFilesystem) error {
+	ctl := &loopControl{
+		vfsObj:  vfsObj,
+		devices: make(map[uint32]*loopDevice),
+	}
+	if err := vfsObj.RegisterDevice(vfs.CharDevice, linux.MISC_MAJOR, linux.LOOP_CTRL_MINOR, ctl, &vfs.RegisterDeviceOptions{
+		Pathname:  "loop-control",
+		FilePerms: devicePerms,
+	}); err != nil {
+		return err
+	}
+	ctl.mu.Lock()
+	defer ctl.mu.Unlock()
+	for number := uint32(0); number < initialDevices; number++ {
+		if _, err := ctl.addLocked(number); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -18,8 +18,8 @@ package tundev
 import (
 	"gvisor.dev/gvisor/pkg/abi/linux"
 	"gvisor.dev/gvisor/pkg/context"
+	"gvisor.dev/gvisor/pkg/marshal/primitive"
 	"gvisor.dev/gvisor/pkg/sentry/arch"
-	"gvisor.dev/gvisor/pkg/sentry/fsimpl/devtmpfs"
 	"gvisor.dev/gvisor/pkg/sentry/inet"
 	"gvisor.dev/gvisor/pkg/sentry/kernel"
 	"gvisor.dev/gvisor/pkg/sentry/socket/netstack"
@@ -103,6 +103,43 @@ func (fd *tunFD) Ioctl(ctx context.Context, uio usermem.IO, args arch.SyscallArg
 		_, err := req.CopyOut(t, data)
 		return 0, err
 
+	case linux.TUNSETPERSIST:
+		if !t.HasCapability(linux.CAP_NET_ADMIN) {
+			return 0, syserror.EPERM
+		}
+		// The argument is passed by value.
+		return 0, fd.device.SetPersist(ctx, args[2].Int() != 0)
+
+	case linux.TUNSETQUEUE:
+		if !t.HasCapability(linux.CAP_NET_ADMIN) {
+			return 0, syserror.EPERM
+		}
+		var req linux.IFReq
+		if _, err := req.CopyIn(t, data); err != nil {
+			return 0, err
+		}
+		flags := usermem.ByteOrder.Uint16(req.Data[:])
+		return 0, fd.device.SetQueue(flags)
+
+	case linux.TUNGETFEATURES:
+		_, err := primitive.CopyUint32Out(t, data, tun.Features())
+		return 0, err
+
+	case linux.TUNSETOFFLOAD:
+		// The argument is passed by value.
+		return 0, fd.device.SetOffload(args[2].Uint())
+
+	case linux.TUNGETVNETHDRSZ:
+		_, err := primitive.CopyInt32Out(t, data, int32(fd.device.VnetHdrSize()))
+		return 0, err
+
+	case linux.TUNSETVNETHDRSZ:
+		var size int32
+		if _, err := primitive.CopyInt32In(t, data, &size); err != nil {
+			return 0, err
+		}
+		return 0, fd.device.SetVnetHdrSize(int(size))
+
 	default:
 		return 0, syserror.ENOTTY
 	}
@@ -169,11 +206,8 @@ func IsNetTunSupported(s inet.Stack) bool {
 
 // Register registers all devices implemented by this package in vfsObj.
 func Register(vfsObj *vfs.VirtualFilesystem) error {
-	return vfsObj.RegisterDevice(vfs.CharDevice, netTunDevMajor, netTunDevMinor, tunDevice{}, &vfs.RegisterDeviceOptions{})
-}
-
-// CreateDevtmpfsFiles creates device special files in dev representing all
-// devices implemented by this package.
-func CreateDevtmpfsFiles(ctx context.Context, dev *devtmpfs.Accessor) error {
-	return dev.CreateDeviceFile(ctx, "net/tun", vfs.CharDevice, netTunDevMajor, netTunDevMinor, 0666 /* mode */)
+	return vfsObj.RegisterDevice(vfs.CharDevice, netTunDevMajor, netTunDevMinor, tunDevice{}, &vfs.RegisterDeviceOptions{
+		Pathname:  "net/tun",
+		FilePerms: 0666,
+	})
 }
@@ -17,6 +17,7 @@ package dev
 import (
 	"gvisor.dev/gvisor/pkg/abi/linux"
 	"gvisor.dev/gvisor/pkg/context"
+	"gvisor.dev/gvisor/pkg/marshal/primitive"
 	"gvisor.dev/gvisor/pkg/sentry/arch"
 	"gvisor.dev/gvisor/pkg/sentry/fs"
 	"gvisor.dev/gvisor/pkg/sentry/fs/fsutil"
@@ -124,6 +125,43 @@ func (n *netTunFileOperations) Ioctl(ctx context.Context, file *fs.File, io user
 		_, err := req.CopyOut(t, data)
 		return 0, err
 
+	case linux.TUNSETPERSIST:
+		if !t.HasCapability(linux.CAP_NET_ADMIN) {
+			return 0, syserror.EPERM
+		}
+		// The argument is passed by value.
+		return 0, n.device.SetPersist(ctx, args[2].Int() != 0)
+
+	case linux.TUNSETQUEUE:
+		if !t.HasCapability(linux.CAP_NET_ADMIN) {
+			return 0, syserror.EPERM
+		}
+		var req linux.IFReq
+		if _, err := req.CopyIn(t, data); err != nil {
+			return 0, err
+		}
+		flags := usermem.ByteOrder.Uint16(req.Data[:])
+		return 0, n.device.SetQueue(flags)
+
+	case linux.TUNGETFEATURES:
+		_, err := primitive.CopyUint32Out(t, data, tun.Features())
+		return 0, err
+
+	case linux.TUNSETOFFLOAD:
+		// The argument is passed by value.
+		return 0, n.device.SetOffload(args[2].Uint())
+
+	case linux.TUNGETVNETHDRSZ:
+		_, err := primitive.CopyInt32Out(t, data, int32(n.device.VnetHdrSize()))
+		return 0, err
+
+	case linux.TUNSETVNETHDRSZ:
+		var size int32
+		if _, err := primitive.CopyInt32In(t, data, &size); err != nil {
+			return 0, err
+		}
+		return 0, n.device.SetVnetHdrSize(int(size))
+
 	default:
 		return 0, syserror.ENOTTY
 	}
@@ -32,7 +32,7 @@ func newCPUInfo(ctx context.Context, msrc *fs.MountSource) *fs.Inode {
 		panic("cpuinfo read with nil FeatureSet")
 	}
 	var buf bytes.Buffer
-	for i, max := uint(0), k.ApplicationCores(); i < max; i++ {
+	for i, max := uint(0), k.OnlineCores(); i < max; i++ {
 		features.WriteCPUInfoTo(i, &buf)
 	}
 	return newStaticProcInode(ctx, msrc, buf.Bytes())
@@ -18,7 +18,9 @@ import (
 	"bytes"
 	"fmt"
 	"io"
+	"net"
 	"reflect"
+	"strings"
 	"time"
 
 	"gvisor.dev/gvisor/pkg/abi/linux"
@@ -49,8 +51,9 @@ func newNetDir(ctx context.Context, t *kernel.Task, msrc *fs.MountSource) *fs.In
 		// TODO(gvisor.dev/issue/1833): Make sure file contents reflect the task
 		// network namespace.
 		contents = map[string]*fs.Inode{
-			"dev":  seqfile.NewSeqFileInode(ctx, &netDev{s: s}, msrc),
-			"snmp": seqfile.NewSeqFileInode(ctx, &netSnmp{s: s}, msrc),
+			"dev":          seqfile.NewSeqFileInode(ctx, &netDev{s: s}, msrc),
+			"nf_conntrack": seqfile.NewSeqFileInode(ctx, &netConntrack{s: s}, msrc),
+			"snmp":         seqfile.NewSeqFileInode(ctx, &netSnmp{s: s}, msrc),
 
 			// The following files are simple stubs until they are
 			// implemented in netstack, if the file contains a
@@ -392,6 +395,96 @@ func (n *netRoute) ReadSeqFileData(ctx context.Context, h seqfile.SeqHandle) ([]
 	return data, 0
 }
 
+// netConntrack implements seqfile.SeqSource for /proc/net/nf_conntrack.
+//
+// +stateify savable
+type netConntrack struct {
+	s inet.Stack
+}
+
+// tcpConntrackStates are the names of the TCP connection tracking states, from
+// net/netfilter/nf_conntrack_proto_tcp.c:tcp_conntrack_names.
+var tcpConntrackStates = [...]string{
+	linux.TCP_CONNTRACK_NONE:        "NONE",
+	linux.TCP_CONNTRACK_SYN_SENT:    "SYN_SENT",
+	linux.TCP_CONNTRACK_SYN_RECV:    "SYN_RECV",
+	linux.TCP_CONNTRACK_ESTABLISHED: "ESTABLISHED",
+	linux.TCP_CONNTRACK_FIN_WAIT:    "FIN_WAIT",
+	linux.TCP_CONNTRACK_CLOSE_WAIT:  "CLOSE_WAIT",
+	linux.TCP_CONNTRACK_LAST_ACK:    "LAST_ACK",
+	linux.TCP_CONNTRACK_TIME_WAIT:   "TIME_WAIT",
+	linux.TCP_CONNTRACK_CLOSE:       "CLOSE",
+	linux.TCP_CONNTRACK_SYN_SENT2:   "SYN_SENT2",
+}
+
+// writeConntrackTuple writes a connection tracking tuple as Linux's
+// net/netfilter/nf_conntrack_standalone.c:print_tuple.
+func writeConntrackTuple(buf *bytes.Buffer, family uint8, tuple inet.ConnTrackTuple) {
+	if family == linux.AF_INET {
+		fmt.Fprintf(buf, "src=%s dst=%s ", net.IP(tuple.SrcAddr), net.IP(tuple.DstAddr))
+	} else {
+		// IPv6 addresses are not compressed.
+		fmt.Fprintf(buf, "src=%s dst=%s ", uncompressedIPv6(tuple.SrcAddr), uncompressedIPv6(tuple.DstAddr))
+	}
+	fmt.Fprintf(buf, "sport=%d dport=%d ", tuple.SrcPort, tuple.DstPort)
+}
+
+// uncompressedIPv6 formats addr as Linux's %pI6.
+func uncompressedIPv6(addr []byte) string {
+	var s strings.Builder
+	for i := 0; i+1 < len(addr); i += 2 {
+		if i > 0 {
+			s.WriteByte(':')
+		}
+		fmt.Fprintf(&s, "%02x%02x", addr[i], addr[i+1])
+	}
+	return s.String()
+}
+
+// NeedsUpdate implements seqfile.SeqSource.NeedsUpdate.
+func (n *netConntrack) NeedsUpdate(generation int64) bool {
+	return true
+}
+
+// ReadSeqFileData implements seqfile.SeqSource.ReadSeqFileData.
+// See Linux's net/netfilter/nf_conntrack_standalone.c:ct_seq_show.
+func (n *netConntrack) ReadSeqFileData(ctx context.Context, h seqfile.SeqHandle) ([]seqfile.SeqData, int64) {
+	if h != nil {
+		return nil, 0
+	}
+
+	entries, err := n.s.ConnTrackEntries()
+	if err != nil {
+		return nil, 0
+	}
+
+	var data []seqfile.SeqData
+	for _, e := range entries {
+		var buf bytes.Buffer
+		l3proto := "ipv4"
+		if e.Family == linux.AF_INET6 {
+			l3proto = "ipv6"
+		}
+		// Only TCP connections are tracked.
+		fmt.Fprintf(&buf, "%-8s %d %-8s %d %d ", l3proto, e.Family, "tcp", e.Protocol, e.Timeout/time.Second)
+		if int(e.TCPState) < len(tcpConntrackStates) {
+			fmt.Fprintf(&buf, "%s ", tcpConntrackStates[e.TCPState])
+		}
+		writeConntrackTuple(&buf, e.Family, e.Original)
+		if e.Status&linux.IPS_SEEN_REPLY == 0 {
+			buf.WriteString("[UNREPLIED] ")
+		}
+		writeConntrackTuple(&buf, e.Family, e.Reply)
+		if e.Status&linux.IPS_ASSURED != 0 {
+			buf.WriteString("[ASSURED] ")
+		}
+		buf.WriteString("mark=0 use=2\n")
+		data = append(data, seqfile.SeqData{Buf: buf.Bytes(), Handle: (*netConntrack)(nil)})
+	}
+
+	return data, 0
+}
+
 // netUnix implements seqfile.SeqSource for /proc/net/unix.
 //
 // +stateify savable
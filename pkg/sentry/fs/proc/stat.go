@@ -90,7 +90,7 @@ func (s *statData) ReadSeqFileData(ctx context.Context, h seqfile.SeqHandle) ([]
 	var cpu cpuStats
 	fmt.Fprintf(&buf, "cpu  %s\n", cpu)
 
-	for c, max := uint(0), s.k.ApplicationCores(); c < max; c++ {
+	for c, max := uint(0), s.k.OnlineCores(); c < max; c++ {
 		fmt.Fprintf(&buf, "cpu%d %s\n", c, cpu)
 	}
 
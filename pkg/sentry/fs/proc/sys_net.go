@@ -17,6 +17,7 @@ package proc
 import (
 	"fmt"
 	"io"
+	"time"
 
 	"gvisor.dev/gvisor/pkg/abi/linux"
 	"gvisor.dev/gvisor/pkg/context"
@@ -26,6 +27,7 @@ import (
 	"gvisor.dev/gvisor/pkg/sentry/fs/ramfs"
 	"gvisor.dev/gvisor/pkg/sentry/inet"
 	"gvisor.dev/gvisor/pkg/sync"
+	"gvisor.dev/gvisor/pkg/syserror"
 	"gvisor.dev/gvisor/pkg/tcpip/network/ipv4"
 	"gvisor.dev/gvisor/pkg/usermem"
 	"gvisor.dev/gvisor/pkg/waiter"
@@ -366,6 +368,322 @@ func (f *tcpRecoveryFile) Write(ctx context.Context, _ *fs.File, src usermem.IOS
 	return n, nil
 }
 
+// tcpECN is used to read/write the TCP ECN settings of the network stack.
+//
+// +stateify savable
+type tcpECN struct {
+	fsutil.SimpleFileInode
+
+	stack inet.Stack `state:"wait"`
+
+	// fallback is true for tcp_ecn_fallback.
+	fallback bool
+}
+
+func newTCPECNInode(ctx context.Context, msrc *fs.MountSource, s inet.Stack, fallback bool) *fs.Inode {
+	te := &tcpECN{
+		SimpleFileInode: *fsutil.NewSimpleFileInode(ctx, fs.RootOwner, fs.FilePermsFromMode(0644), linux.PROC_SUPER_MAGIC),
+		stack:           s,
+		fallback:        fallback,
+	}
+	sattr := fs.StableAttr{
+		DeviceID:  device.ProcDevice.DeviceID(),
+		InodeID:   device.ProcDevice.NextIno(),
+		BlockSize: usermem.PageSize,
+		Type:      fs.SpecialFile,
+	}
+	return fs.NewInode(ctx, te, msrc, sattr)
+}
+
+// Truncate implements fs.InodeOperations.Truncate.
+func (*tcpECN) Truncate(context.Context, *fs.Inode, int64) error {
+	return nil
+}
+
+// GetFile implements fs.InodeOperations.GetFile.
+func (t *tcpECN) GetFile(ctx context.Context, dirent *fs.Dirent, flags fs.FileFlags) (*fs.File, error) {
+	flags.Pread = true
+	flags.Pwrite = true
+	return fs.NewFile(ctx, dirent, flags, &tcpECNFile{
+		stack:    t.stack,
+		fallback: t.fallback,
+	}), nil
+}
+
+// +stateify savable
+type tcpECNFile struct {
+	fsutil.FileGenericSeek          `state:"nosave"`
+	fsutil.FileNoIoctl              `state:"nosave"`
+	fsutil.FileNoMMap               `state:"nosave"`
+	fsutil.FileNoSplice             `state:"nosave"`
+	fsutil.FileNoopRelease          `state:"nosave"`
+	fsutil.FileNoopFlush            `state:"nosave"`
+	fsutil.FileNoopFsync            `state:"nosave"`
+	fsutil.FileNotDirReaddir        `state:"nosave"`
+	fsutil.FileUseInodeUnstableAttr `state:"nosave"`
+	waiter.AlwaysReady              `state:"nosave"`
+
+	stack    inet.Stack `state:"wait"`
+	fallback bool
+}
+
+// Read implements fs.FileOperations.Read.
+func (f *tcpECNFile) Read(ctx context.Context, _ *fs.File, dst usermem.IOSequence, offset int64) (int64, error) {
+	if offset != 0 {
+		return 0, io.EOF
+	}
+
+	var v int32
+	if f.fallback {
+		fallback, err := f.stack.TCPECNFallback()
+		if err != nil {
+			return 0, err
+		}
+		if fallback {
+			v = 1
+		}
+	} else {
+		mode, err := f.stack.TCPECN()
+		if err != nil {
+			return 0, err
+		}
+		v = int32(mode)
+	}
+	n, err := dst.CopyOut(ctx, []byte(fmt.Sprintf("%d\n", v)))
+	return int64(n), err
+}
+
+// Write implements fs.FileOperations.Write.
+func (f *tcpECNFile) Write(ctx context.Context, _ *fs.File, src usermem.IOSequence, offset int64) (int64, error) {
+	if src.NumBytes() == 0 {
+		return 0, nil
+	}
+	src = src.TakeFirst(usermem.PageSize - 1)
+
+	var v int32
+	n, err := usermem.CopyInt32StringInVec(ctx, src.IO, src.Addrs, &v, src.Opts)
+	if err != nil {
+		return 0, err
+	}
+	if f.fallback {
+		if v < 0 || v > 1 {
+			return 0, syserror.EINVAL
+		}
+		err = f.stack.SetTCPECNFallback(v != 0)
+	} else {
+		err = f.stack.SetTCPECN(inet.TCPECNMode(v))
+	}
+	if err != nil {
+		return 0, err
+	}
+	return n, nil
+}
+
+// tcpSynCookies is used to read/write the TCP listen queue overflow settings
+// of the network stack.
+//
+// +stateify savable
+type tcpSynCookies struct {
+	fsutil.SimpleFileInode
+
+	stack inet.Stack `state:"wait"`
+
+	// abortOnOverflow is true for tcp_abort_on_overflow.
+	abortOnOverflow bool
+}
+
+func newTCPSynCookiesInode(ctx context.Context, msrc *fs.MountSource, s inet.Stack, abortOnOverflow bool) *fs.Inode {
+	ts := &tcpSynCookies{
+		SimpleFileInode: *fsutil.NewSimpleFileInode(ctx, fs.RootOwner, fs.FilePermsFromMode(0644), linux.PROC_SUPER_MAGIC),
+		stack:           s,
+		abortOnOverflow: abortOnOverflow,
+	}
+	sattr := fs.StableAttr{
+		DeviceID:  device.ProcDevice.DeviceID(),
+		InodeID:   device.ProcDevice.NextIno(),
+		BlockSize: usermem.PageSize,
+		Type:      fs.SpecialFile,
+	}
+	return fs.NewInode(ctx, ts, msrc, sattr)
+}
+
+// Truncate implements fs.InodeOperations.Truncate.
+func (*tcpSynCookies) Truncate(context.Context, *fs.Inode, int64) error {
+	return nil
+}
+
+// GetFile implements fs.InodeOperations.GetFile.
+func (t *tcpSynCookies) GetFile(ctx context.Context, dirent *fs.Dirent, flags fs.FileFlags) (*fs.File, error) {
+	flags.Pread = true
+	flags.Pwrite = true
+	return fs.NewFile(ctx, dirent, flags, &tcpSynCookiesFile{
+		stack:           t.stack,
+		abortOnOverflow: t.abortOnOverflow,
+	}), nil
+}
+
+// +stateify savable
+type tcpSynCookiesFile struct {
+	fsutil.FileGenericSeek          `state:"nosave"`
+	fsutil.FileNoIoctl              `state:"nosave"`
+	fsutil.FileNoMMap               `state:"nosave"`
+	fsutil.FileNoSplice             `state:"nosave"`
+	fsutil.FileNoopRelease          `state:"nosave"`
+	fsutil.FileNoopFlush            `state:"nosave"`
+	fsutil.FileNoopFsync            `state:"nosave"`
+	fsutil.FileNotDirReaddir        `state:"nosave"`
+	fsutil.FileUseInodeUnstableAttr `state:"nosave"`
+	waiter.AlwaysReady              `state:"nosave"`
+
+	stack           inet.Stack `state:"wait"`
+	abortOnOverflow bool
+}
+
+// Read implements fs.FileOperations.Read.
+func (f *tcpSynCookiesFile) Read(ctx context.Context, _ *fs.File, dst usermem.IOSequence, offset int64) (int64, error) {
+	if offset != 0 {
+		return 0, io.EOF
+	}
+
+	var v int32
+	if f.abortOnOverflow {
+		abort, err := f.stack.TCPAbortOnOverflow()
+		if err != nil {
+			return 0, err
+		}
+		if abort {
+			v = 1
+		}
+	} else {
+		mode, err := f.stack.TCPSynCookies()
+		if err != nil {
+			return 0, err
+		}
+		v = int32(mode)
+	}
+	n, err := dst.CopyOut(ctx, []byte(fmt.Sprintf("%d\n", v)))
+	return int64(n), err
+}
+
+// Write implements fs.FileOperations.Write.
+func (f *tcpSynCookiesFile) Write(ctx context.Context, _ *fs.File, src usermem.IOSequence, offset int64) (int64, error) {
+	if src.NumBytes() == 0 {
+		return 0, nil
+	}
+	src = src.TakeFirst(usermem.PageSize - 1)
+
+	var v int32
+	n, err := usermem.CopyInt32StringInVec(ctx, src.IO, src.Addrs, &v, src.Opts)
+	if err != nil {
+		return 0, err
+	}
+	if f.abortOnOverflow {
+		if v < 0 || v > 1 {
+			return 0, syserror.EINVAL
+		}
+		err = f.stack.SetTCPAbortOnOverflow(v != 0)
+	} else {
+		err = f.stack.SetTCPSynCookies(inet.TCPSynCookiesMode(v))
+	}
+	if err != nil {
+		return 0, err
+	}
+	return n, nil
+}
+
+// tcpMigrateReq is used to read/write whether the connections queued on closing
+// listening sockets are migrated to another socket of their SO_REUSEPORT group.
+//
+// +stateify savable
+type tcpMigrateReq struct {
+	fsutil.SimpleFileInode
+
+	stack inet.Stack `state:"wait"`
+}
+
+func newTCPMigrateReqInode(ctx context.Context, msrc *fs.MountSource, s inet.Stack) *fs.Inode {
+	tm := &tcpMigrateReq{
+		SimpleFileInode: *fsutil.NewSimpleFileInode(ctx, fs.RootOwner, fs.FilePermsFromMode(0644), linux.PROC_SUPER_MAGIC),
+		stack:           s,
+	}
+	sattr := fs.StableAttr{
+		DeviceID:  device.ProcDevice.DeviceID(),
+		InodeID:   device.ProcDevice.NextIno(),
+		BlockSize: usermem.PageSize,
+		Type:      fs.SpecialFile,
+	}
+	return fs.NewInode(ctx, tm, msrc, sattr)
+}
+
+// Truncate implements fs.InodeOperations.Truncate.
+func (*tcpMigrateReq) Truncate(context.Context, *fs.Inode, int64) error {
+	return nil
+}
+
+// GetFile implements fs.InodeOperations.GetFile.
+func (t *tcpMigrateReq) GetFile(ctx context.Context, dirent *fs.Dirent, flags fs.FileFlags) (*fs.File, error) {
+	flags.Pread = true
+	flags.Pwrite = true
+	return fs.NewFile(ctx, dirent, flags, &tcpMigrateReqFile{
+		stack: t.stack,
+	}), nil
+}
+
+// +stateify savable
+type tcpMigrateReqFile struct {
+	fsutil.FileGenericSeek          `state:"nosave"`
+	fsutil.FileNoIoctl              `state:"nosave"`
+	fsutil.FileNoMMap               `state:"nosave"`
+	fsutil.FileNoSplice             `state:"nosave"`
+	fsutil.FileNoopRelease          `state:"nosave"`
+	fsutil.FileNoopFlush            `state:"nosave"`
+	fsutil.FileNoopFsync            `state:"nosave"`
+	fsutil.FileNotDirReaddir        `state:"nosave"`
+	fsutil.FileUseInodeUnstableAttr `state:"nosave"`
+	waiter.AlwaysReady              `state:"nosave"`
+
+	stack inet.Stack `state:"wait"`
+}
+
+// Read implements fs.FileOperations.Read.
+func (f *tcpMigrateReqFile) Read(ctx context.Context, _ *fs.File, dst usermem.IOSequence, offset int64) (int64, error) {
+	if offset != 0 {
+		return 0, io.EOF
+	}
+
+	migrate, err := f.stack.TCPMigrateReq()
+	if err != nil {
+		return 0, err
+	}
+	val := "0\n"
+	if migrate {
+		val = "1\n"
+	}
+	n, err := dst.CopyOut(ctx, []byte(val))
+	return int64(n), err
+}
+
+// Write implements fs.FileOperations.Write.
+func (f *tcpMigrateReqFile) Write(ctx context.Context, _ *fs.File, src usermem.IOSequence, offset int64) (int64, error) {
+	if src.NumBytes() == 0 {
+		return 0, nil
+	}
+	src = src.TakeFirst(usermem.PageSize - 1)
+
+	var v int32
+	n, err := usermem.CopyInt32StringInVec(ctx, src.IO, src.Addrs, &v, src.Opts)
+	if err != nil {
+		return 0, err
+	}
+	if v < 0 || v > 1 {
+		return 0, syserror.EINVAL
+	}
+	if err := f.stack.SetTCPMigrateReq(v != 0); err != nil {
+		return 0, err
+	}
+	return n, nil
+}
+
 func (p *proc) newSysNetCore(ctx context.Context, msrc *fs.MountSource, s inet.Stack) *fs.Inode {
 	// The following files are simple stubs until they are implemented in
 	// netstack, most of these files are configuration related. We use the
@@ -564,6 +882,362 @@ func (p *proc) newSysNetIPv4Dir(ctx context.Context, msrc *fs.MountSource, s ine
 		contents["tcp_recovery"] = newTCPRecoveryInode(ctx, msrc, s)
 	}
 
+	// Add tcp_ecn and tcp_ecn_fallback.
+	if _, err := s.TCPECN(); err == nil {
+		contents["tcp_ecn"] = newTCPECNInode(ctx, msrc, s, false /* fallback */)
+		contents["tcp_ecn_fallback"] = newTCPECNInode(ctx, msrc, s, true /* fallback */)
+	}
+
+	// Add tcp_syncookies and tcp_abort_on_overflow.
+	if _, err := s.TCPSynCookies(); err == nil {
+		contents["tcp_syncookies"] = newTCPSynCookiesInode(ctx, msrc, s, false /* abortOnOverflow */)
+		contents["tcp_abort_on_overflow"] = newTCPSynCookiesInode(ctx, msrc, s, true /* abortOnOverflow */)
+	}
+
+	// Add tcp_migrate_req.
+	if _, err := s.TCPMigrateReq(); err == nil {
+		contents["tcp_migrate_req"] = newTCPMigrateReqInode(ctx, msrc, s)
+	}
+
+	d := ramfs.NewDir(ctx, contents, fs.RootOwner, fs.FilePermsFromMode(0555))
+	return newProcInode(ctx, d, msrc, fs.SpecialDirectory, nil)
+}
+
+// conntrackSetting identifies one of the connection tracking sysctls.
+type conntrackSetting int
+
+const (
+	conntrackMax conntrackSetting = iota
+	conntrackCount
+	conntrackBuckets
+	conntrackTCPTimeoutSynSent
+	conntrackTCPTimeoutEstablished
+	conntrackTCPTimeoutTimeWait
+	conntrackTCPTimeoutClose
+)
+
+// conntrackInode is used to read/write the connection tracking settings of
+// the network stack.
+//
+// The settings themselves are saved along with the network stack, so there is
+// nothing to restore here.
+//
+// +stateify savable
+type conntrackInode struct {
+	fsutil.SimpleFileInode
+
+	stack   inet.Stack `state:"wait"`
+	setting conntrackSetting
+}
+
+func newConntrackInode(ctx context.Context, msrc *fs.MountSource, s inet.Stack, setting conntrackSetting) *fs.Inode {
+	mode := linux.FileMode(0644)
+	if setting == conntrackCount {
+		mode = 0444
+	}
+	ci := &conntrackInode{
+		SimpleFileInode: *fsutil.NewSimpleFileInode(ctx, fs.RootOwner, fs.FilePermsFromMode(mode), linux.PROC_SUPER_MAGIC),
+		stack:           s,
+		setting:         setting,
+	}
+	sattr := fs.StableAttr{
+		DeviceID:  device.ProcDevice.DeviceID(),
+		InodeID:   device.ProcDevice.NextIno(),
+		BlockSize: usermem.PageSize,
+		Type:      fs.SpecialFile,
+	}
+	return fs.NewInode(ctx, ci, msrc, sattr)
+}
+
+// Truncate implements fs.InodeOperations.Truncate.
+func (*conntrackInode) Truncate(context.Context, *fs.Inode, int64) error {
+	return nil
+}
+
+// GetFile implements fs.InodeOperations.GetFile.
+func (c *conntrackInode) GetFile(ctx context.Context, dirent *fs.Dirent, flags fs.FileFlags) (*fs.File, error) {
+	flags.Pread = true
+	flags.Pwrite = true
+	return fs.NewFile(ctx, dirent, flags, &conntrackFile{
+		stack:   c.stack,
+		setting: c.setting,
+	}), nil
+}
+
+// +stateify savable
+type conntrackFile struct {
+	fsutil.FileGenericSeek          `state:"nosave"`
+	fsutil.FileNoIoctl              `state:"nosave"`
+	fsutil.FileNoMMap               `state:"nosave"`
+	fsutil.FileNoSplice             `state:"nosave"`
+	fsutil.FileNoopRelease          `state:"nosave"`
+	fsutil.FileNoopFlush            `state:"nosave"`
+	fsutil.FileNoopFsync            `state:"nosave"`
+	fsutil.FileNotDirReaddir        `state:"nosave"`
+	fsutil.FileUseInodeUnstableAttr `state:"nosave"`
+	waiter.AlwaysReady              `state:"nosave"`
+
+	stack   inet.Stack `state:"wait"`
+	setting conntrackSetting
+}
+
+// Read implements fs.FileOperations.Read.
+func (f *conntrackFile) Read(ctx context.Context, _ *fs.File, dst usermem.IOSequence, offset int64) (int64, error) {
+	if offset != 0 {
+		return 0, io.EOF
+	}
+
+	var v uint64
+	if f.setting == conntrackCount {
+		stats, err := f.stack.ConnTrackStats()
+		if err != nil {
+			return 0, err
+		}
+		v = uint64(stats.Entries)
+	} else {
+		s, err := f.stack.ConnTrackSettings()
+		if err != nil {
+			return 0, err
+		}
+		switch f.setting {
+		case conntrackMax:
+			v = uint64(s.Max)
+		case conntrackBuckets:
+			v = uint64(s.Buckets)
+		case conntrackTCPTimeoutSynSent:
+			v = uint64(s.TCPTimeoutSynSent / time.Second)
+		case conntrackTCPTimeoutEstablished:
+			v = uint64(s.TCPTimeoutEstablished / time.Second)
+		case conntrackTCPTimeoutTimeWait:
+			v = uint64(s.TCPTimeoutTimeWait / time.Second)
+		case conntrackTCPTimeoutClose:
+			v = uint64(s.TCPTimeoutClose / time.Second)
+		}
+	}
+	n, err := dst.CopyOut(ctx, []byte(fmt.Sprintf("%d\n", v)))
+	return int64(n), err
+}
+
+// Write implements fs.FileOperations.Write.
+func (f *conntrackFile) Write(ctx context.Context, _ *fs.File, src usermem.IOSequence, offset int64) (int64, error) {
+	if f.setting == conntrackCount {
+		// Like Linux, the connection count can't be written even by root.
+		return 0, syserror.EACCES
+	}
+	if src.NumBytes() == 0 {
+		return 0, nil
+	}
+	src = src.TakeFirst(usermem.PageSize - 1)
+
+	var v int32
+	n, err := usermem.CopyInt32StringInVec(ctx, src.IO, src.Addrs, &v, src.Opts)
+	if err != nil {
+		return 0, err
+	}
+	if v < 0 {
+		return 0, syserror.EINVAL
+	}
+
+	s, err := f.stack.ConnTrackSettings()
+	if err != nil {
+		return 0, err
+	}
+	switch f.setting {
+	case conntrackMax:
+		s.Max = uint32(v)
+	case conntrackBuckets:
+		if v == 0 {
+			return 0, syserror.EINVAL
+		}
+		s.Buckets = uint32(v)
+	case conntrackTCPTimeoutSynSent:
+		s.TCPTimeoutSynSent = time.Duration(v) * time.Second
+	case conntrackTCPTimeoutEstablished:
+		s.TCPTimeoutEstablished = time.Duration(v) * time.Second
+	case conntrackTCPTimeoutTimeWait:
+		s.TCPTimeoutTimeWait = time.Duration(v) * time.Second
+	case conntrackTCPTimeoutClose:
+		s.TCPTimeoutClose = time.Duration(v) * time.Second
+	}
+	if err := f.stack.SetConnTrackSettings(s); err != nil {
+		return 0, err
+	}
+	return n, nil
+}
+
+func (p *proc) newSysNetNetfilterDir(ctx context.Context, msrc *fs.MountSource, s inet.Stack) *fs.Inode {
+	contents := map[string]*fs.Inode{
+		"nf_conntrack_buckets":                 newConntrackInode(ctx, msrc, s, conntrackBuckets),
+		"nf_conntrack_count":                   newConntrackInode(ctx, msrc, s, conntrackCount),
+		"nf_conntrack_max":                     newConntrackInode(ctx, msrc, s, conntrackMax),
+		"nf_conntrack_tcp_timeout_close":       newConntrackInode(ctx, msrc, s, conntrackTCPTimeoutClose),
+		"nf_conntrack_tcp_timeout_established": newConntrackInode(ctx, msrc, s, conntrackTCPTimeoutEstablished),
+		"nf_conntrack_tcp_timeout_syn_sent":    newConntrackInode(ctx, msrc, s, conntrackTCPTimeoutSynSent),
+		"nf_conntrack_tcp_timeout_time_wait":   newConntrackInode(ctx, msrc, s, conntrackTCPTimeoutTimeWait),
+	}
+	d := ramfs.NewDir(ctx, contents, fs.RootOwner, fs.FilePermsFromMode(0555))
+	return newProcInode(ctx, d, msrc, fs.SpecialDirectory, nil)
+}
+
+// tempAddrSetting identifies one of the IPv6 temporary address sysctls.
+type tempAddrSetting int
+
+const (
+	tempAddrUse tempAddrSetting = iota
+	tempAddrValidLifetime
+	tempAddrPreferredLifetime
+)
+
+// tempAddrInode is used to read/write the IPv6 temporary address settings of
+// the network stack.
+//
+// The settings themselves are saved along with the network stack, so there is
+// nothing to restore here.
+//
+// +stateify savable
+type tempAddrInode struct {
+	fsutil.SimpleFileInode
+
+	stack   inet.Stack `state:"wait"`
+	setting tempAddrSetting
+}
+
+func newTempAddrInode(ctx context.Context, msrc *fs.MountSource, s inet.Stack, setting tempAddrSetting) *fs.Inode {
+	ti := &tempAddrInode{
+		SimpleFileInode: *fsutil.NewSimpleFileInode(ctx, fs.RootOwner, fs.FilePermsFromMode(0644), linux.PROC_SUPER_MAGIC),
+		stack:           s,
+		setting:         setting,
+	}
+	sattr := fs.StableAttr{
+		DeviceID:  device.ProcDevice.DeviceID(),
+		InodeID:   device.ProcDevice.NextIno(),
+		BlockSize: usermem.PageSize,
+		Type:      fs.SpecialFile,
+	}
+	return fs.NewInode(ctx, ti, msrc, sattr)
+}
+
+// Truncate implements fs.InodeOperations.Truncate.
+func (*tempAddrInode) Truncate(context.Context, *fs.Inode, int64) error {
+	return nil
+}
+
+// GetFile implements fs.InodeOperations.GetFile.
+func (t *tempAddrInode) GetFile(ctx context.Context, dirent *fs.Dirent, flags fs.FileFlags) (*fs.File, error) {
+	flags.Pread = true
+	flags.Pwrite = true
+	return fs.NewFile(ctx, dirent, flags, &tempAddrFile{
+		stack:   t.stack,
+		setting: t.setting,
+	}), nil
+}
+
+// +stateify savable
+type tempAddrFile struct {
+	fsutil.FileGenericSeek          `state:"nosave"`
+	fsutil.FileNoIoctl              `state:"nosave"`
+	fsutil.FileNoMMap               `state:"nosave"`
+	fsutil.FileNoSplice             `state:"nosave"`
+	fsutil.FileNoopRelease          `state:"nosave"`
+	fsutil.FileNoopFlush            `state:"nosave"`
+	fsutil.FileNoopFsync            `state:"nosave"`
+	fsutil.FileNotDirReaddir        `state:"nosave"`
+	fsutil.FileUseInodeUnstableAttr `state:"nosave"`
+	waiter.AlwaysReady              `state:"nosave"`
+
+	stack   inet.Stack `state:"wait"`
+	setting tempAddrSetting
+}
+
+// Read implements fs.FileOperations.Read.
+func (f *tempAddrFile) Read(ctx context.Context, _ *fs.File, dst usermem.IOSequence, offset int64) (int64, error) {
+	if offset != 0 {
+		return 0, io.EOF
+	}
+
+	s, err := f.stack.IPv6TempAddrSettings()
+	if err != nil {
+		return 0, err
+	}
+	var v int64
+	switch f.setting {
+	case tempAddrUse:
+		v = int64(s.UseTempAddr)
+	case tempAddrValidLifetime:
+		v = int64(s.ValidLifetime / time.Second)
+	case tempAddrPreferredLifetime:
+		v = int64(s.PreferredLifetime / time.Second)
+	}
+	n, err := dst.CopyOut(ctx, []byte(fmt.Sprintf("%d\n", v)))
+	return int64(n), err
+}
+
+// Write implements fs.FileOperations.Write.
+func (f *tempAddrFile) Write(ctx context.Context, _ *fs.File, src usermem.IOSequence, offset int64) (int64, error) {
+	if src.NumBytes() == 0 {
+		return 0, nil
+	}
+	src = src.TakeFirst(usermem.PageSize - 1)
+
+	var v int32
+	n, err := usermem.CopyInt32StringInVec(ctx, src.IO, src.Addrs, &v, src.Opts)
+	if err != nil {
+		return 0, err
+	}
+
+	s, err := f.stack.IPv6TempAddrSettings()
+	if err != nil {
+		return 0, err
+	}
+	switch f.setting {
+	case tempAddrUse:
+		// As in Linux, values above 1 also prefer temporary addresses and
+		// negative values disable them.
+		switch {
+		case v <= 0:
+			s.UseTempAddr = 0
+		case v == 1:
+			s.UseTempAddr = 1
+		default:
+			s.UseTempAddr = 2
+		}
+	case tempAddrValidLifetime, tempAddrPreferredLifetime:
+		if v < 0 {
+			return 0, syserror.EINVAL
+		}
+		if f.setting == tempAddrValidLifetime {
+			s.ValidLifetime = time.Duration(v) * time.Second
+		} else {
+			s.PreferredLifetime = time.Duration(v) * time.Second
+		}
+	}
+	if err := f.stack.SetIPv6TempAddrSettings(s); err != nil {
+		return 0, err
+	}
+	return n, nil
+}
+
+// newSysNetIPv6ConfDir returns the /proc/sys/net/ipv6/conf/{all,default}
+// directories. The settings apply to all interfaces.
+func (p *proc) newSysNetIPv6ConfDir(ctx context.Context, msrc *fs.MountSource, s inet.Stack) *fs.Inode {
+	contents := map[string]*fs.Inode{
+		"temp_prefered_lft": newTempAddrInode(ctx, msrc, s, tempAddrPreferredLifetime),
+		"temp_valid_lft":    newTempAddrInode(ctx, msrc, s, tempAddrValidLifetime),
+		"use_tempaddr":      newTempAddrInode(ctx, msrc, s, tempAddrUse),
+	}
+	d := ramfs.NewDir(ctx, contents, fs.RootOwner, fs.FilePermsFromMode(0555))
+	return newProcInode(ctx, d, msrc, fs.SpecialDirectory, nil)
+}
+
+func (p *proc) newSysNetIPv6Dir(ctx context.Context, msrc *fs.MountSource, s inet.Stack) *fs.Inode {
+	conf := map[string]*fs.Inode{
+		"all":     p.newSysNetIPv6ConfDir(ctx, msrc, s),
+		"default": p.newSysNetIPv6ConfDir(ctx, msrc, s),
+	}
+	confDir := ramfs.NewDir(ctx, conf, fs.RootOwner, fs.FilePermsFromMode(0555))
+	contents := map[string]*fs.Inode{
+		"conf": newProcInode(ctx, confDir, msrc, fs.SpecialDirectory, nil),
+	}
 	d := ramfs.NewDir(ctx, contents, fs.RootOwner, fs.FilePermsFromMode(0555))
 	return newProcInode(ctx, d, msrc, fs.SpecialDirectory, nil)
 }
@@ -577,6 +1251,17 @@ func (p *proc) newSysNetDir(ctx context.Context, msrc *fs.MountSource) *fs.Inode
 			"ipv4": p.newSysNetIPv4Dir(ctx, msrc, s),
 			"core": p.newSysNetCore(ctx, msrc, s),
 		}
+
+		// Connection tracking knobs are only available if the stack tracks
+		// connections.
+		if _, err := s.ConnTrackSettings(); err == nil {
+			contents["netfilter"] = p.newSysNetNetfilterDir(ctx, msrc, s)
+			contents["nf_conntrack_max"] = newConntrackInode(ctx, msrc, s, conntrackMax)
+		}
+
+		if s.SupportsIPv6() {
+			contents["ipv6"] = p.newSysNetIPv6Dir(ctx, msrc, s)
+		}
 	}
 	d := ramfs.NewDir(ctx, contents, fs.RootOwner, fs.FilePermsFromMode(0555))
 	return newProcInode(ctx, d, msrc, fs.SpecialDirectory, nil)
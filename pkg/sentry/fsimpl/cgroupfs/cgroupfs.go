@@ -0,0 +1,397 @@
+// Copyright 2020 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package cgroupfs implements an emulation of the cgroup v2 filesystem.
+//
+// The emulated hierarchy tracks which thread groups are members of which
+// cgroups, enforces the structural rules of cgroup v2 (e.g. the "no internal
+// processes" rule), and reports usage computed from the sentry's accounting.
+// The limits written to the interface files of controllers are recorded, so
+// that container runtimes running in the sandbox can configure their
+// containers, but they are not enforced.
+//
+// There is a single hierarchy per sandbox, which backs all mounts.
+//
+// Lock order:
+//
+// kernfs.Filesystem.mu
+//   filesystem.hierarchyMu
+//     kernel.TaskSet.mu
+package cgroupfs
+
+import (
+	"strings"
+	"sync"
+
+	"gvisor.dev/gvisor/pkg/abi/linux"
+	"gvisor.dev/gvisor/pkg/context"
+	"gvisor.dev/gvisor/pkg/sentry/fsimpl/kernfs"
+	"gvisor.dev/gvisor/pkg/sentry/kernel"
+	"gvisor.dev/gvisor/pkg/sentry/kernel/auth"
+	"gvisor.dev/gvisor/pkg/sentry/vfs"
+	"gvisor.dev/gvisor/pkg/syserror"
+)
+
+// Name is the name of the filesystem type.
+const Name = "cgroup2"
+
+// FilesystemType implements vfs.FilesystemType.
+//
+// +stateify savable
+type FilesystemType struct {
+	initOnce sync.Once `state:"nosave"`
+	initErr  error
+
+	// fs backs all mounts of this FilesystemType. root is fs' root. fs and root
+	// are immutable.
+	fs   *vfs.Filesystem
+	root *vfs.Dentry
+}
+
+// Name implements vfs.FilesystemType.Name.
+func (*FilesystemType) Name() string {
+	return Name
+}
+
+// InitHierarchy creates the cgroup hierarchy, if it doesn't exist yet, and
+// makes its root the cgroup of the thread groups created afterwards. It should
+// be called before the first process is created, so that all processes are
+// members of the hierarchy.
+func (fstype *FilesystemType) InitHierarchy(ctx context.Context, vfsObj *vfs.VirtualFilesystem, creds *auth.Credentials) error {
+	fstype.initOnce.Do(func() {
+		fs, root, err := fstype.newFilesystem(ctx, vfsObj, creds)
+		if err != nil {
+			fstype.initErr = err
+			return
+		}
+		fstype.fs = fs.VFSFilesystem()
+		fstype.root = root.VFSDentry()
+		kernel.KernelFromContext(ctx).SetCgroupRoot(fs.root)
+	})
+	return fstype.initErr
+}
+
+// GetFilesystem implements vfs.FilesystemType.GetFilesystem.
+func (fstype *FilesystemType) GetFilesystem(ctx context.Context, vfsObj *vfs.VirtualFilesystem, creds *auth.Credentials, source string, opts vfs.GetFilesystemOptions) (*vfs.Filesystem, *vfs.Dentry, error) {
+	// Mount options of cgroup2 only change behavior that isn't emulated, so
+	// they are accepted and ignored.
+	for _, opt := range strings.Split(opts.Data, ",") {
+		switch opt {
+		case "", "nsdelegate", "memory_localevents", "memory_recursiveprot", "favordynmods":
+		default:
+			ctx.Warningf("cgroupfs.FilesystemType.GetFilesystem: unknown mount option %q", opt)
+			return nil, nil, syserror.EINVAL
+		}
+	}
+
+	if err := fstype.InitHierarchy(ctx, vfsObj, creds); err != nil {
+		return nil, nil, err
+	}
+	fstype.fs.IncRef()
+	fstype.root.IncRef()
+	return fstype.fs, fstype.root, nil
+}
+
+// Release implements vfs.FilesystemType.Release.
+func (fstype *FilesystemType) Release(ctx context.Context) {
+	if fstype.fs != nil {
+		fstype.root.DecRef(ctx)
+		fstype.fs.DecRef(ctx)
+	}
+}
+
+// filesystem implements vfs.FilesystemImpl.
+//
+// +stateify savable
+type filesystem struct {
+	kernfs.Filesystem
+
+	devMinor uint32
+
+	// hierarchyMu serializes changes to the hierarchy: creating and removing
+	// cgroups, moving thread groups between cgroups and changing the
+	// controllers and interface files of cgroups.
+	hierarchyMu sync.Mutex `state:"nosave"`
+
+	// root is the root cgroup. root is immutable.
+	root *cgroupInode
+}
+
+// newFilesystem creates the filesystem that backs the hierarchy. It returns
+// the filesystem and its root Dentry.
+func (fstype *FilesystemType) newFilesystem(ctx context.Context, vfsObj *vfs.VirtualFilesystem, creds *auth.Credentials) (*filesystem, *kernfs.Dentry, error) {
+	devMinor, err := vfsObj.GetAnonBlockDevMinor()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	fs := &filesystem{
+		devMinor: devMinor,
+	}
+	fs.VFSFilesystem().Init(vfsObj, fstype, fs)
+	fs.root = fs.newCgroupInode(ctx, creds, nil /* parent */, "" /* name */, 0755)
+
+	var rootD kernfs.Dentry
+	rootD.InitRoot(&fs.Filesystem, fs.root)
+	return fs, &rootD, nil
+}
+
+// Release implements vfs.FilesystemImpl.Release.
+func (fs *filesystem) Release(ctx context.Context) {
+	fs.Filesystem.VFSFilesystem().VirtualFilesystem().PutAnonBlockDevMinor(fs.devMinor)
+	fs.Filesystem.Release(ctx)
+}
+
+// cgroupInode is the directory of a cgroup. It implements kernfs.Inode and
+// kernel.Cgroup.
+//
+// +stateify savable
+type cgroupInode struct {
+	dirRefs
+	implStatFS
+	kernfs.InodeAlwaysValid
+	kernfs.InodeAttrs
+	kernfs.InodeNotSymlink
+	kernfs.OrderedChildren
+
+	locks vfs.FileLocks
+
+	fs *filesystem
+
+	// parent is the parent cgroup, or nil for the root cgroup. name is the
+	// name of the cgroup's directory in its parent. parent and name are
+	// immutable, as cgroups can't be renamed.
+	parent *cgroupInode
+	name   string
+
+	// The fields below are protected by fs.hierarchyMu.
+
+	// children maps the names of child cgroups to their directory.
+	children map[string]*cgroupInode
+
+	// subtreeControl is the set of controllers enabled for the children of
+	// the cgroup.
+	subtreeControl map[string]struct{}
+
+	// values maps the names of the interface files of controllers to their
+	// contents.
+	values map[string]string
+
+	// dead is true if the cgroup was removed.
+	dead bool
+}
+
+var _ kernel.Cgroup = (*cgroupInode)(nil)
+var _ kernfs.Inode = (*cgroupInode)(nil)
+
+// newCgroupInode creates the directory of a cgroup, with its interface files.
+func (fs *filesystem) newCgroupInode(ctx context.Context, creds *auth.Credentials, parent *cgroupInode, name string, perm linux.FileMode) *cgroupInode {
+	c := &cgroupInode{
+		fs:             fs,
+		parent:         parent,
+		name:           name,
+		children:       make(map[string]*cgroupInode),
+		subtreeControl: make(map[string]struct{}),
+		values:         make(map[string]string),
+	}
+	c.InodeAttrs.Init(ctx, creds, linux.UNNAMED_MAJOR, fs.devMinor, fs.NextIno(), linux.ModeDirectory|perm)
+	c.OrderedChildren.Init(kernfs.OrderedChildrenOptions{
+		Writable: true,
+	})
+	c.InitRefs()
+	c.IncLinks(c.OrderedChildren.Populate(fs.newInterfaceFiles(ctx, creds, c)))
+	return c
+}
+
+// Path implements kernel.Cgroup.Path.
+func (c *cgroupInode) Path() string {
+	if c.parent == nil {
+		return "/"
+	}
+	var names []string
+	for ; c.parent != nil; c = c.parent {
+		names = append(names, c.name)
+	}
+	var b strings.Builder
+	for i := len(names) - 1; i >= 0; i-- {
+		b.WriteString("/")
+		b.WriteString(names[i])
+	}
+	return b.String()
+}
+
+// cgroupOf returns the directory of cg. Thread groups that were created before
+// the hierarchy are members of the root cgroup.
+func (fs *filesystem) cgroupOf(cg kernel.Cgroup) *cgroupInode {
+	if c, ok := cg.(*cgroupInode); ok && c.fs == fs {
+		return c
+	}
+	return fs.root
+}
+
+// is returns a function that reports whether a cgroup is c.
+func (c *cgroupInode) is() func(kernel.Cgroup) bool {
+	return func(cg kernel.Cgroup) bool {
+		return c.fs.cgroupOf(cg) == c
+	}
+}
+
+// contains returns a function that reports whether a cgroup is c or one of
+// its descendants.
+func (c *cgroupInode) contains() func(kernel.Cgroup) bool {
+	return func(cg kernel.Cgroup) bool {
+		for d := c.fs.cgroupOf(cg); d != nil; d = d.parent {
+			if d == c {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// hasProcesses returns true if any thread group is a member of c itself.
+func (c *cgroupInode) hasProcesses(ctx context.Context) bool {
+	return len(kernel.KernelFromContext(ctx).TaskSet().ThreadGroupsInCgroups(c.is())) > 0
+}
+
+// availableControllersLocked returns the controllers that may be enabled in
+// c's subtree_control, in the order Linux lists them.
+//
+// Preconditions: c.fs.hierarchyMu must be locked.
+func (c *cgroupInode) availableControllersLocked() []string {
+	if c.parent == nil {
+		return controllers
+	}
+	var available []string
+	for _, name := range controllers {
+		if _, ok := c.parent.subtreeControl[name]; ok {
+			available = append(available, name)
+		}
+	}
+	return available
+}
+
+// Open implements kernfs.Inode.Open.
+func (c *cgroupInode) Open(ctx context.Context, rp *vfs.ResolvingPath, kd *kernfs.Dentry, opts vfs.OpenOptions) (*vfs.FileDescription, error) {
+	fd, err := kernfs.NewGenericDirectoryFD(rp.Mount(), kd, &c.OrderedChildren, &c.locks, &opts, kernfs.GenericDirectoryFDOptions{
+		SeekEnd: kernfs.SeekEndStaticEntries,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return fd.VFSFileDescription(), nil
+}
+
+// NewFile implements kernfs.Inode.NewFile.
+func (c *cgroupInode) NewFile(ctx context.Context, name string, opts vfs.OpenOptions) (kernfs.Inode, error) {
+	return nil, syserror.EPERM
+}
+
+// NewDir implements kernfs.Inode.NewDir. It creates a child cgroup.
+func (c *cgroupInode) NewDir(ctx context.Context, name string, opts vfs.MkdirOptions) (kernfs.Inode, error) {
+	c.fs.hierarchyMu.Lock()
+	defer c.fs.hierarchyMu.Unlock()
+	if c.dead {
+		return nil, syserror.ENOENT
+	}
+	if strings.Contains(name, "\n") {
+		return nil, syserror.EINVAL
+	}
+	child := c.fs.newCgroupInode(ctx, auth.CredentialsFromContext(ctx), c, name, opts.Mode&linux.PermissionsMask)
+	if err := c.OrderedChildren.Insert(name, child); err != nil {
+		child.DecRef(ctx)
+		return nil, err
+	}
+	c.children[name] = child
+	c.IncLinks(1)
+	c.TouchCMtime(ctx)
+	return child, nil
+}
+
+// NewLink implements kernfs.Inode.NewLink.
+func (c *cgroupInode) NewLink(ctx context.Context, name string, target kernfs.Inode) (kernfs.Inode, error) {
+	return nil, syserror.EPERM
+}
+
+// NewSymlink implements kernfs.Inode.NewSymlink.
+func (c *cgroupInode) NewSymlink(ctx context.Context, name, target string) (kernfs.Inode, error) {
+	return nil, syserror.EPERM
+}
+
+// NewNode implements kernfs.Inode.NewNode.
+func (c *cgroupInode) NewNode(ctx context.Context, name string, opts vfs.MknodOptions) (kernfs.Inode, error) {
+	return nil, syserror.EPERM
+}
+
+// HasChildren implements kernfs.Inode.HasChildren. Interface files don't
+// prevent the removal of a cgroup, only child cgroups do.
+func (c *cgroupInode) HasChildren() bool {
+	c.fs.hierarchyMu.Lock()
+	defer c.fs.hierarchyMu.Unlock()
+	return len(c.children) > 0
+}
+
+// Unlink implements kernfs.Inode.Unlink. Interface files can't be removed.
+func (c *cgroupInode) Unlink(ctx context.Context, name string, child kernfs.Inode) error {
+	return syserror.EPERM
+}
+
+// RmDir implements kernfs.Inode.RmDir. It removes a child cgroup, which must
+// not have processes.
+func (c *cgroupInode) RmDir(ctx context.Context, name string, child kernfs.Inode) error {
+	c.fs.hierarchyMu.Lock()
+	defer c.fs.hierarchyMu.Unlock()
+	cg, ok := c.children[name]
+	if !ok || cg != child {
+		return syserror.ENOENT
+	}
+	if len(cg.children) > 0 {
+		return syserror.ENOTEMPTY
+	}
+	if cg.hasProcesses(ctx) {
+		return syserror.EBUSY
+	}
+	if err := c.OrderedChildren.RmDir(ctx, name, child); err != nil {
+		return err
+	}
+	delete(c.children, name)
+	cg.dead = true
+	c.DecLinks()
+	c.TouchCMtime(ctx)
+	return nil
+}
+
+// Rename implements kernfs.Inode.Rename.
+func (c *cgroupInode) Rename(ctx context.Context, oldname, newname string, child, dstDir kernfs.Inode) error {
+	return syserror.EPERM
+}
+
+// Keep implements kernfs.Inode.Keep. Cgroups only go away when they are
+// removed.
+func (c *cgroupInode) Keep() bool {
+	return true
+}
+
+// DecRef implements kernfs.Inode.DecRef.
+func (c *cgroupInode) DecRef(ctx context.Context) {
+	c.dirRefs.DecRef(func() { c.Destroy(ctx) })
+}
+
+// +stateify savable
+type implStatFS struct{}
+
+// StatFS implements kernfs.Inode.StatFS.
+func (*implStatFS) StatFS(context.Context, *vfs.Filesystem) (linux.Statfs, error) {
+	return vfs.GenericStatFS(linux.CGROUP2_SUPER_MAGIC), nil
+}
@@ -0,0 +1,411 @@
+// Copyright 2020 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cgroupfs
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"gvisor.dev/gvisor/pkg/abi/linux"
+	"gvisor.dev/gvisor/pkg/context"
+	"gvisor.dev/gvisor/pkg/sentry/fsimpl/kernfs"
+	"gvisor.dev/gvisor/pkg/sentry/kernel"
+	"gvisor.dev/gvisor/pkg/sentry/kernel/auth"
+	"gvisor.dev/gvisor/pkg/sentry/vfs"
+	"gvisor.dev/gvisor/pkg/syserror"
+	"gvisor.dev/gvisor/pkg/usermem"
+)
+
+// controllers are the controllers of the hierarchy, in the order Linux lists
+// them.
+var controllers = []string{"cpuset", "cpu", "io", "memory", "pids"}
+
+// controllerFileSpec describes an interface file of a controller whose
+// contents are recorded.
+type controllerFileSpec struct {
+	// controller is the controller the file belongs to.
+	controller string
+
+	// initial is the initial contents of the file.
+	initial string
+
+	// parse returns the contents of the file after input is written to it,
+	// given its current contents, or EINVAL if input is invalid.
+	parse func(contents, input string) (string, error)
+}
+
+// controllerFiles maps the names of the recorded interface files of
+// controllers, which exist in every non-root cgroup, to their description.
+var controllerFiles = map[string]controllerFileSpec{
+	"cpu.max":         {"cpu", "max 100000\n", parseCPUMax},
+	"cpu.weight":      {"cpu", "100\n", parseWeight},
+	"cpuset.cpus":     {"cpuset", "\n", parseCPUList},
+	"cpuset.mems":     {"cpuset", "\n", parseCPUList},
+	"io.max":          {"io", "", parseIOMax},
+	"io.weight":       {"io", "default 100\n", parseIOWeight},
+	"memory.high":     {"memory", "max\n", parseMemory},
+	"memory.low":      {"memory", "0\n", parseMemory},
+	"memory.max":      {"memory", "max\n", parseMemory},
+	"memory.min":      {"memory", "0\n", parseMemory},
+	"memory.swap.max": {"memory", "max\n", parseMemory},
+	"pids.max":        {"pids", "max\n", parseMax},
+}
+
+// newInterfaceFiles returns the interface files of cgroup c.
+func (fs *filesystem) newInterfaceFiles(ctx context.Context, creds *auth.Credentials, c *cgroupInode) map[string]kernfs.Inode {
+	files := map[string]kernfs.Inode{
+		"cgroup.controllers":     fs.newFile(ctx, creds, 0444, &controllersData{cg: c}),
+		"cgroup.procs":           fs.newFile(ctx, creds, 0644, &procsData{cg: c}),
+		"cgroup.subtree_control": fs.newFile(ctx, creds, 0644, &subtreeControlData{cg: c}),
+		"cpu.stat":               fs.newFile(ctx, creds, 0444, &cpuStatData{cg: c}),
+		"cpuset.cpus.effective":  fs.newFile(ctx, creds, 0444, &effectiveCPUsData{cg: c}),
+		"cpuset.mems.effective":  fs.newFile(ctx, creds, 0444, &staticData{data: "0\n"}),
+	}
+	if c.parent == nil {
+		return files
+	}
+	files["cgroup.events"] = fs.newFile(ctx, creds, 0444, &eventsData{cg: c})
+	files["cgroup.type"] = fs.newFile(ctx, creds, 0444, &staticData{data: "domain\n"})
+	files["memory.current"] = fs.newFile(ctx, creds, 0444, &memoryCurrentData{cg: c})
+	files["pids.current"] = fs.newFile(ctx, creds, 0444, &pidsCurrentData{cg: c})
+	for name, spec := range controllerFiles {
+		c.values[name] = spec.initial
+		files[name] = fs.newFile(ctx, creds, 0644, &controllerData{cg: c, name: name})
+	}
+	return files
+}
+
+// file is an interface file of a cgroup.
+//
+// +stateify savable
+type file struct {
+	implStatFS
+	kernfs.DynamicBytesFile
+}
+
+func (fs *filesystem) newFile(ctx context.Context, creds *auth.Credentials, perm linux.FileMode, data vfs.DynamicBytesSource) kernfs.Inode {
+	f := &file{}
+	f.DynamicBytesFile.Init(ctx, creds, linux.UNNAMED_MAJOR, fs.devMinor, fs.NextIno(), data, perm)
+	return f
+}
+
+// SetStat implements kernfs.Inode.SetStat. Unlike most synthetic files,
+// interface files may be chowned, which is how cgroups are delegated.
+func (f *file) SetStat(ctx context.Context, fs *vfs.Filesystem, creds *auth.Credentials, opts vfs.SetStatOptions) error {
+	return f.InodeAttrs.SetStat(ctx, fs, creds, opts)
+}
+
+// readInput copies the input written to an interface file, without leading
+// and trailing whitespace.
+func readInput(ctx context.Context, src usermem.IOSequence, offset int64) (string, error) {
+	srclen := src.NumBytes()
+	if srclen >= usermem.PageSize || offset != 0 {
+		return "", syserror.EINVAL
+	}
+	b := make([]byte, srclen)
+	if _, err := src.CopyIn(ctx, b); err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(b)), nil
+}
+
+// staticData is the contents of a file that never changes.
+//
+// +stateify savable
+type staticData struct {
+	data string
+}
+
+// Generate implements vfs.DynamicBytesSource.Generate.
+func (d *staticData) Generate(ctx context.Context, buf *bytes.Buffer) error {
+	buf.WriteString(d.data)
+	return nil
+}
+
+// controllersData is the contents of cgroup.controllers.
+//
+// +stateify savable
+type controllersData struct {
+	cg *cgroupInode
+}
+
+// Generate implements vfs.DynamicBytesSource.Generate.
+func (d *controllersData) Generate(ctx context.Context, buf *bytes.Buffer) error {
+	d.cg.fs.hierarchyMu.Lock()
+	defer d.cg.fs.hierarchyMu.Unlock()
+	fmt.Fprintf(buf, "%s\n", strings.Join(d.cg.availableControllersLocked(), " "))
+	return nil
+}
+
+// subtreeControlData is the contents of cgroup.subtree_control.
+//
+// +stateify savable
+type subtreeControlData struct {
+	cg *cgroupInode
+}
+
+var _ vfs.WritableDynamicBytesSource = (*subtreeControlData)(nil)
+
+// Generate implements vfs.DynamicBytesSource.Generate.
+func (d *subtreeControlData) Generate(ctx context.Context, buf *bytes.Buffer) error {
+	d.cg.fs.hierarchyMu.Lock()
+	defer d.cg.fs.hierarchyMu.Unlock()
+	var enabled []string
+	for _, name := range controllers {
+		if _, ok := d.cg.subtreeControl[name]; ok {
+			enabled = append(enabled, name)
+		}
+	}
+	fmt.Fprintf(buf, "%s\n", strings.Join(enabled, " "))
+	return nil
+}
+
+// Write implements vfs.WritableDynamicBytesSource.Write.
+func (d *subtreeControlData) Write(ctx context.Context, src usermem.IOSequence, offset int64) (int64, error) {
+	input, err := readInput(ctx, src, offset)
+	if err != nil {
+		return 0, err
+	}
+	enable, disable, err := parseSubtreeControl(input)
+	if err != nil {
+		return 0, err
+	}
+
+	c := d.cg
+	c.fs.hierarchyMu.Lock()
+	defer c.fs.hierarchyMu.Unlock()
+	if c.dead {
+		return 0, syserror.ENODEV
+	}
+	available := make(map[string]struct{})
+	for _, name := range c.availableControllersLocked() {
+		available[name] = struct{}{}
+	}
+	for _, name := range enable {
+		if _, ok := available[name]; !ok {
+			return 0, syserror.ENOENT
+		}
+	}
+	for _, name := range disable {
+		// Controllers can't be disabled while children use them.
+		for _, child := range c.children {
+			if _, ok := child.subtreeControl[name]; ok {
+				return 0, syserror.EBUSY
+			}
+		}
+	}
+	// Non-root cgroups can't both have processes and distribute resources
+	// to their children ("no internal processes" rule).
+	if len(enable) > 0 && len(c.subtreeControl) == 0 && c.parent != nil && c.hasProcesses(ctx) {
+		return 0, syserror.EBUSY
+	}
+	for _, name := range enable {
+		c.subtreeControl[name] = struct{}{}
+	}
+	for _, name := range disable {
+		delete(c.subtreeControl, name)
+	}
+	return src.NumBytes(), nil
+}
+
+// procsData is the contents of cgroup.procs.
+//
+// +stateify savable
+type procsData struct {
+	cg *cgroupInode
+}
+
+var _ vfs.WritableDynamicBytesSource = (*procsData)(nil)
+
+// Generate implements vfs.DynamicBytesSource.Generate.
+func (d *procsData) Generate(ctx context.Context, buf *bytes.Buffer) error {
+	t := kernel.TaskFromContext(ctx)
+	if t == nil {
+		return nil
+	}
+	pidns := t.PIDNamespace()
+	var tgids []int
+	for _, tg := range t.Kernel().TaskSet().ThreadGroupsInCgroups(d.cg.is()) {
+		// Processes that aren't visible in the reader's PID namespace
+		// aren't listed.
+		if tgid := pidns.IDOfThreadGroup(tg); tgid != 0 {
+			tgids = append(tgids, int(tgid))
+		}
+	}
+	sort.Ints(tgids)
+	for _, tgid := range tgids {
+		fmt.Fprintf(buf, "%d\n", tgid)
+	}
+	return nil
+}
+
+// Write implements vfs.WritableDynamicBytesSource.Write. It moves the process
+// whose PID is written to the cgroup. Writing 0 moves the writer.
+func (d *procsData) Write(ctx context.Context, src usermem.IOSequence, offset int64) (int64, error) {
+	input, err := readInput(ctx, src, offset)
+	if err != nil {
+		return 0, err
+	}
+	pid, err := strconv.ParseInt(input, 10, 32)
+	if err != nil || pid < 0 {
+		return 0, syserror.EINVAL
+	}
+	t := kernel.TaskFromContext(ctx)
+	if t == nil {
+		return 0, syserror.ESRCH
+	}
+	tg := t.ThreadGroup()
+	if pid != 0 {
+		if tg = t.PIDNamespace().ThreadGroupWithID(kernel.ThreadID(pid)); tg == nil {
+			return 0, syserror.ESRCH
+		}
+	}
+
+	c := d.cg
+	c.fs.hierarchyMu.Lock()
+	defer c.fs.hierarchyMu.Unlock()
+	if c.dead {
+		return 0, syserror.ENODEV
+	}
+	if c.parent != nil && len(c.subtreeControl) > 0 {
+		return 0, syserror.EBUSY
+	}
+	tg.SetCgroup(c)
+	return src.NumBytes(), nil
+}
+
+// eventsData is the contents of cgroup.events.
+//
+// +stateify savable
+type eventsData struct {
+	cg *cgroupInode
+}
+
+// Generate implements vfs.DynamicBytesSource.Generate.
+func (d *eventsData) Generate(ctx context.Context, buf *bytes.Buffer) error {
+	populated := 0
+	if len(kernel.KernelFromContext(ctx).TaskSet().ThreadGroupsInCgroups(d.cg.contains())) > 0 {
+		populated = 1
+	}
+	fmt.Fprintf(buf, "populated %d\nfrozen 0\n", populated)
+	return nil
+}
+
+// cpuStatData is the contents of cpu.stat.
+//
+// +stateify savable
+type cpuStatData struct {
+	cg *cgroupInode
+}
+
+// Generate implements vfs.DynamicBytesSource.Generate.
+func (d *cpuStatData) Generate(ctx context.Context, buf *bytes.Buffer) error {
+	u := kernel.KernelFromContext(ctx).TaskSet().CgroupUsage(d.cg.contains())
+	fmt.Fprintf(buf, "usage_usec %d\n", (u.CPU.UserTime + u.CPU.SysTime).Microseconds())
+	fmt.Fprintf(buf, "user_usec %d\n", u.CPU.UserTime.Microseconds())
+	fmt.Fprintf(buf, "system_usec %d\n", u.CPU.SysTime.Microseconds())
+	return nil
+}
+
+// memoryCurrentData is the contents of memory.current.
+//
+// +stateify savable
+type memoryCurrentData struct {
+	cg *cgroupInode
+}
+
+// Generate implements vfs.DynamicBytesSource.Generate.
+func (d *memoryCurrentData) Generate(ctx context.Context, buf *bytes.Buffer) error {
+	u := kernel.KernelFromContext(ctx).TaskSet().CgroupUsage(d.cg.contains())
+	fmt.Fprintf(buf, "%d\n", u.RSS)
+	return nil
+}
+
+// pidsCurrentData is the contents of pids.current.
+//
+// +stateify savable
+type pidsCurrentData struct {
+	cg *cgroupInode
+}
+
+// Generate implements vfs.DynamicBytesSource.Generate.
+func (d *pidsCurrentData) Generate(ctx context.Context, buf *bytes.Buffer) error {
+	u := kernel.KernelFromContext(ctx).TaskSet().CgroupUsage(d.cg.contains())
+	fmt.Fprintf(buf, "%d\n", u.Tasks)
+	return nil
+}
+
+// effectiveCPUsData is the contents of cpuset.cpus.effective.
+//
+// +stateify savable
+type effectiveCPUsData struct {
+	cg *cgroupInode
+}
+
+// Generate implements vfs.DynamicBytesSource.Generate.
+func (d *effectiveCPUsData) Generate(ctx context.Context, buf *bytes.Buffer) error {
+	d.cg.fs.hierarchyMu.Lock()
+	defer d.cg.fs.hierarchyMu.Unlock()
+	// The closest configured cpuset.cpus applies, or all CPUs.
+	for c := d.cg; c.parent != nil; c = c.parent {
+		if cpus := c.values["cpuset.cpus"]; cpus != "\n" {
+			buf.WriteString(cpus)
+			return nil
+		}
+	}
+	fmt.Fprintf(buf, "0-%d\n", kernel.KernelFromContext(ctx).ApplicationCores()-1)
+	return nil
+}
+
+// controllerData is the contents of a recorded interface file of a
+// controller.
+//
+// +stateify savable
+type controllerData struct {
+	cg   *cgroupInode
+	name string
+}
+
+var _ vfs.WritableDynamicBytesSource = (*controllerData)(nil)
+
+// Generate implements vfs.DynamicBytesSource.Generate.
+func (d *controllerData) Generate(ctx context.Context, buf *bytes.Buffer) error {
+	d.cg.fs.hierarchyMu.Lock()
+	defer d.cg.fs.hierarchyMu.Unlock()
+	buf.WriteString(d.cg.values[d.name])
+	return nil
+}
+
+// Write implements vfs.WritableDynamicBytesSource.Write.
+func (d *controllerData) Write(ctx context.Context, src usermem.IOSequence, offset int64) (int64, error) {
+	input, err := readInput(ctx, src, offset)
+	if err != nil {
+		return 0, err
+	}
+	d.cg.fs.hierarchyMu.Lock()
+	defer d.cg.fs.hierarchyMu.Unlock()
+	if d.cg.dead {
+		return 0, syserror.ENODEV
+	}
+	contents, err := controllerFiles[d.name].parse(d.cg.values[d.name], input)
+	if err != nil {
+		return 0, err
+	}
+	d.cg.values[d.name] = contents
+	return src.NumBytes(), nil
+}
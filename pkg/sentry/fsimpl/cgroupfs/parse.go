@@ -0,0 +1,311 @@
+// Copyright 2020 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cgroupfs
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"gvisor.dev/gvisor/pkg/syserror"
+	"gvisor.dev/gvisor/pkg/usermem"
+)
+
+// parseSubtreeControl parses input written to cgroup.subtree_control, a
+// space-separated list of controllers prefixed by '+' to enable them or '-'
+// to disable them.
+func parseSubtreeControl(input string) (enable, disable []string, err error) {
+	for _, tok := range strings.Fields(input) {
+		name := tok[1:]
+		if !isController(name) {
+			return nil, nil, syserror.EINVAL
+		}
+		switch tok[0] {
+		case '+':
+			enable = append(enable, name)
+		case '-':
+			disable = append(disable, name)
+		default:
+			return nil, nil, syserror.EINVAL
+		}
+	}
+	return enable, disable, nil
+}
+
+// isController returns true if name is a controller of the hierarchy.
+func isController(name string) bool {
+	for _, c := range controllers {
+		if c == name {
+			return true
+		}
+	}
+	return false
+}
+
+// parseMax parses a value that is "max" or a number, such as pids.max.
+func parseMax(_, input string) (string, error) {
+	if input == "max" {
+		return "max\n", nil
+	}
+	v, err := strconv.ParseUint(input, 10, 64)
+	if err != nil {
+		return "", syserror.EINVAL
+	}
+	return fmt.Sprintf("%d\n", v), nil
+}
+
+// parseMemory parses an amount of memory, such as memory.max. It is "max" or
+// a number of bytes with an optional K, M, G or T suffix, which is rounded
+// down to a multiple of the page size, as in Linux.
+func parseMemory(_, input string) (string, error) {
+	if input == "max" {
+		return "max\n", nil
+	}
+	shift := uint(0)
+	if n := len(input); n > 0 {
+		switch input[n-1] {
+		case 'k', 'K':
+			shift = 10
+		case 'm', 'M':
+			shift = 20
+		case 'g', 'G':
+			shift = 30
+		case 't', 'T':
+			shift = 40
+		}
+		if shift != 0 {
+			input = input[:n-1]
+		}
+	}
+	v, err := strconv.ParseUint(input, 10, 64)
+	if err != nil || v > (^uint64(0))>>shift {
+		return "", syserror.EINVAL
+	}
+	v <<= shift
+	return fmt.Sprintf("%d\n", v&^uint64(usermem.PageSize-1)), nil
+}
+
+// parseWeight parses a weight, such as cpu.weight, in [1, 10000].
+func parseWeight(_, input string) (string, error) {
+	v, err := strconv.ParseUint(input, 10, 64)
+	if err != nil || v < 1 || v > 10000 {
+		return "", syserror.EINVAL
+	}
+	return fmt.Sprintf("%d\n", v), nil
+}
+
+// parseCPUMax parses cpu.max, "$MAX $PERIOD", where $MAX is "max" or a quota
+// in microseconds. The period is kept if only the quota is written.
+func parseCPUMax(contents, input string) (string, error) {
+	const minPeriod, maxPeriod = 1000, 1000000
+	fields := strings.Fields(input)
+	if len(fields) == 0 || len(fields) > 2 {
+		return "", syserror.EINVAL
+	}
+	quota := fields[0]
+	if quota != "max" {
+		v, err := strconv.ParseUint(quota, 10, 64)
+		if err != nil || v < minPeriod {
+			return "", syserror.EINVAL
+		}
+		quota = strconv.FormatUint(v, 10)
+	}
+	period := strings.Fields(contents)[1]
+	if len(fields) == 2 {
+		v, err := strconv.ParseUint(fields[1], 10, 64)
+		if err != nil || v < minPeriod || v > maxPeriod {
+			return "", syserror.EINVAL
+		}
+		period = strconv.FormatUint(v, 10)
+	}
+	return fmt.Sprintf("%s %s\n", quota, period), nil
+}
+
+// parseCPUList parses a list of CPUs or memory nodes, such as cpuset.cpus,
+// e.g. "0-3,5". An empty list resets the file.
+func parseCPUList(_, input string) (string, error) {
+	if input == "" {
+		return "\n", nil
+	}
+	for _, r := range strings.Split(input, ",") {
+		bounds := strings.SplitN(r, "-", 2)
+		first, err := strconv.ParseUint(bounds[0], 10, 32)
+		if err != nil {
+			return "", syserror.EINVAL
+		}
+		if len(bounds) == 2 {
+			last, err := strconv.ParseUint(bounds[1], 10, 32)
+			if err != nil || last < first {
+				return "", syserror.EINVAL
+			}
+		}
+	}
+	return input + "\n", nil
+}
+
+// parseDevice parses a device number, "$MAJ:$MIN".
+func parseDevice(s string) (major, minor uint32, err error) {
+	parts := strings.SplitN(s, ":", 2)
+	if len(parts) != 2 {
+		return 0, 0, syserror.EINVAL
+	}
+	maj, err := strconv.ParseUint(parts[0], 10, 32)
+	if err != nil {
+		return 0, 0, syserror.EINVAL
+	}
+	min, err := strconv.ParseUint(parts[1], 10, 32)
+	if err != nil {
+		return 0, 0, syserror.EINVAL
+	}
+	return uint32(maj), uint32(min), nil
+}
+
+// deviceLines maps device numbers to the settings of the devices in a file
+// with a line per device, such as io.max.
+type deviceLines map[string]string
+
+// parseDeviceLines parses the lines of contents that start with a device
+// number.
+func parseDeviceLines(contents string) deviceLines {
+	lines := make(deviceLines)
+	for _, line := range strings.Split(contents, "\n") {
+		fields := strings.SplitN(line, " ", 2)
+		if len(fields) != 2 {
+			continue
+		}
+		if _, _, err := parseDevice(fields[0]); err == nil {
+			lines[fields[0]] = fields[1]
+		}
+	}
+	return lines
+}
+
+// String returns the lines, ordered by device number.
+func (l deviceLines) String() string {
+	devs := make([]string, 0, len(l))
+	for dev := range l {
+		devs = append(devs, dev)
+	}
+	sort.Slice(devs, func(i, j int) bool {
+		imaj, imin, _ := parseDevice(devs[i])
+		jmaj, jmin, _ := parseDevice(devs[j])
+		if imaj != jmaj {
+			return imaj < jmaj
+		}
+		return imin < jmin
+	})
+	var b strings.Builder
+	for _, dev := range devs {
+		fmt.Fprintf(&b, "%s %s\n", dev, l[dev])
+	}
+	return b.String()
+}
+
+// ioMaxKeys are the limits of io.max, in the order Linux lists them.
+var ioMaxKeys = []string{"rbps", "wbps", "riops", "wiops"}
+
+// parseIOMax parses io.max, which has a line per device with limits, e.g.
+// "8:16 rbps=2097152 wiops=max". Limits that aren't written are kept, and
+// devices whose limits are all "max" are omitted.
+func parseIOMax(contents, input string) (string, error) {
+	fields := strings.Fields(input)
+	if len(fields) == 0 {
+		return "", syserror.EINVAL
+	}
+	maj, min, err := parseDevice(fields[0])
+	if err != nil {
+		return "", err
+	}
+	dev := fmt.Sprintf("%d:%d", maj, min)
+
+	lines := parseDeviceLines(contents)
+	limits := make(map[string]string)
+	for _, key := range ioMaxKeys {
+		limits[key] = "max"
+	}
+	for _, kv := range strings.Fields(lines[dev]) {
+		if parts := strings.SplitN(kv, "=", 2); len(parts) == 2 {
+			limits[parts[0]] = parts[1]
+		}
+	}
+	for _, kv := range fields[1:] {
+		parts := strings.SplitN(kv, "=", 2)
+		if len(parts) != 2 {
+			return "", syserror.EINVAL
+		}
+		if _, ok := limits[parts[0]]; !ok {
+			return "", syserror.EINVAL
+		}
+		v, err := parseMax("", parts[1])
+		if err != nil {
+			return "", err
+		}
+		limits[parts[0]] = strings.TrimSpace(v)
+	}
+
+	var settings []string
+	unlimited := true
+	for _, key := range ioMaxKeys {
+		settings = append(settings, key+"="+limits[key])
+		unlimited = unlimited && limits[key] == "max"
+	}
+	if unlimited {
+		delete(lines, dev)
+	} else {
+		lines[dev] = strings.Join(settings, " ")
+	}
+	return lines.String(), nil
+}
+
+// parseIOWeight parses io.weight, whose first line is the default weight and
+// whose other lines are the weights of devices. "$WEIGHT" or
+// "default $WEIGHT" sets the default weight, "$MAJ:$MIN $WEIGHT" sets the
+// weight of a device and "$MAJ:$MIN default" resets it.
+func parseIOWeight(contents, input string) (string, error) {
+	lines := parseDeviceLines(contents)
+	def := strings.TrimPrefix(strings.SplitN(contents, "\n", 2)[0], "default ")
+
+	fields := strings.Fields(input)
+	switch {
+	case len(fields) == 1:
+		fields = []string{"default", fields[0]}
+	case len(fields) != 2:
+		return "", syserror.EINVAL
+	}
+	if fields[0] == "default" {
+		v, err := parseWeight("", fields[1])
+		if err != nil {
+			return "", err
+		}
+		def = strings.TrimSpace(v)
+	} else {
+		maj, min, err := parseDevice(fields[0])
+		if err != nil {
+			return "", err
+		}
+		dev := fmt.Sprintf("%d:%d", maj, min)
+		if fields[1] == "default" {
+			delete(lines, dev)
+		} else {
+			v, err := parseWeight("", fields[1])
+			if err != nil {
+				return "", err
+			}
+			lines[dev] = strings.TrimSpace(v)
+		}
+	}
+	return fmt.Sprintf("default %s\n%s", def, lines), nil
+}
@@ -0,0 +1,85 @@
+// Copyright 2020 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cgroupfs
+
+import (
+	"testing"
+)
+
+func TestParseControllerFiles(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		file     string
+		contents string
+		input    string
+		want     string
+		wantErr  bool
+	}{
+		{name: "memory max", file: "memory.max", input: "max", want: "max\n"},
+		{name: "memory bytes", file: "memory.max", input: "1048577", want: "1048576\n"},
+		{name: "memory suffix", file: "memory.max", input: "512M", want: "536870912\n"},
+		{name: "memory invalid", file: "memory.max", input: "-1", wantErr: true},
+		{name: "pids", file: "pids.max", input: "100", want: "100\n"},
+		{name: "weight", file: "cpu.weight", input: "200", want: "200\n"},
+		{name: "weight out of range", file: "cpu.weight", input: "0", wantErr: true},
+		{name: "cpu max quota", file: "cpu.max", contents: "max 100000\n", input: "50000", want: "50000 100000\n"},
+		{name: "cpu max period", file: "cpu.max", contents: "max 100000\n", input: "max 200000", want: "max 200000\n"},
+		{name: "cpu max invalid period", file: "cpu.max", contents: "max 100000\n", input: "50000 10", wantErr: true},
+		{name: "cpus", file: "cpuset.cpus", input: "0-3,5", want: "0-3,5\n"},
+		{name: "cpus reset", file: "cpuset.cpus", input: "", want: "\n"},
+		{name: "cpus invalid", file: "cpuset.cpus", input: "3-1", wantErr: true},
+		{name: "io max", file: "io.max", input: "8:16 rbps=2097152", want: "8:16 rbps=2097152 wbps=max riops=max wiops=max\n"},
+		{name: "io max merge", file: "io.max", contents: "8:16 rbps=2097152 wbps=max riops=max wiops=max\n", input: "8:16 wiops=120", want: "8:16 rbps=2097152 wbps=max riops=max wiops=120\n"},
+		{name: "io max remove", file: "io.max", contents: "8:16 rbps=2097152 wbps=max riops=max wiops=max\n", input: "8:16 rbps=max", want: ""},
+		{name: "io max invalid key", file: "io.max", input: "8:16 foo=1", wantErr: true},
+		{name: "io weight default", file: "io.weight", contents: "default 100\n", input: "50", want: "default 50\n"},
+		{name: "io weight device", file: "io.weight", contents: "default 100\n", input: "8:0 200", want: "default 100\n8:0 200\n"},
+		{name: "io weight device reset", file: "io.weight", contents: "default 100\n8:0 200\n", input: "8:0 default", want: "default 100\n"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := controllerFiles[tc.file].parse(tc.contents, tc.input)
+			if tc.wantErr {
+				if err == nil {
+					t.Fatalf("parsing %q for %s: got %q, want error", tc.input, tc.file, got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parsing %q for %s: %v", tc.input, tc.file, err)
+			}
+			if got != tc.want {
+				t.Errorf("parsing %q for %s: got %q, want %q", tc.input, tc.file, got, tc.want)
+			}
+		})
+	}
+}
+
+func TestParseSubtreeControl(t *testing.T) {
+	enable, disable, err := parseSubtreeControl("+cpu +memory -io")
+	if err != nil {
+		t.Fatalf("parseSubtreeControl: %v", err)
+	}
+	if len(enable) != 2 || enable[0] != "cpu" || enable[1] != "memory" {
+		t.Errorf("enable: got %v, want [cpu memory]", enable)
+	}
+	if len(disable) != 1 || disable[0] != "io" {
+		t.Errorf("disable: got %v, want [io]", disable)
+	}
+	for _, input := range []string{"cpu", "+foo", "+"} {
+		if _, _, err := parseSubtreeControl(input); err == nil {
+			t.Errorf("parseSubtreeControl(%q): got nil error, want EINVAL", input)
+		}
+	}
+}
@@ -184,6 +184,22 @@ func (a *Accessor) CreateDeviceFile(ctx context.Context, pathname string, kind v
 	})
 }
 
+// CreateRegisteredDeviceFiles creates a device special file for each device
+// registered in the VirtualFilesystem with a pathname, as Linux's devtmpfs
+// does when device drivers register devices. Devices must be registered
+// before calling CreateRegisteredDeviceFiles for their files to be created.
+func (a *Accessor) CreateRegisteredDeviceFiles(ctx context.Context) error {
+	return a.vfsObj.ForEachDevice(func(kind vfs.DeviceKind, major, minor uint32, opts *vfs.RegisterDeviceOptions) error {
+		if opts.Pathname == "" {
+			return nil
+		}
+		if err := a.CreateDeviceFile(ctx, opts.Pathname, kind, major, minor, opts.FilePerms); err != nil {
+			return fmt.Errorf("failed to create %s device file %q (%d, %d): %v", kind, opts.Pathname, major, minor, err)
+		}
+		return nil
+	})
+}
+
 // UserspaceInit creates symbolic links and mount points in the devtmpfs
 // instance accessed by the Accessor that are created by userspace in Linux. It
 // does not create mounts.
@@ -25,6 +25,7 @@ import (
 	"gvisor.dev/gvisor/pkg/sentry/fsimpl/tmpfs"
 	"gvisor.dev/gvisor/pkg/sentry/kernel/auth"
 	"gvisor.dev/gvisor/pkg/sentry/vfs"
+	"gvisor.dev/gvisor/pkg/syserror"
 )
 
 const devPath = "/dev"
@@ -228,3 +229,81 @@ func TestCreateDeviceFile(t *testing.T) {
 		}
 	}
 }
+
+// testDevice implements vfs.Device.
+type testDevice struct{}
+
+// Open implements vfs.Device.Open.
+func (testDevice) Open(ctx context.Context, mnt *vfs.Mount, vfsd *vfs.Dentry, opts vfs.OpenOptions) (*vfs.FileDescription, error) {
+	return nil, syserror.ENXIO
+}
+
+func TestCreateRegisteredDeviceFiles(t *testing.T) {
+	ctx, creds, vfsObj, root, cleanup := setupDevtmpfs(t)
+	defer cleanup()
+
+	for _, dev := range []struct {
+		kind  vfs.DeviceKind
+		major uint32
+		minor uint32
+		opts  vfs.RegisterDeviceOptions
+	}{
+		{
+			kind:  vfs.CharDevice,
+			major: 12,
+			minor: 34,
+			opts:  vfs.RegisterDeviceOptions{Pathname: "foo/bar", FilePerms: 0600},
+		},
+		{
+			kind:  vfs.BlockDevice,
+			major: 13,
+			minor: 35,
+			opts:  vfs.RegisterDeviceOptions{Pathname: "baz", FilePerms: 0660},
+		},
+		{
+			// Devices without a pathname don't get a device special file.
+			kind:  vfs.CharDevice,
+			major: 12,
+			minor: 35,
+		},
+	} {
+		if err := vfsObj.RegisterDevice(dev.kind, dev.major, dev.minor, testDevice{}, &dev.opts); err != nil {
+			t.Fatalf("failed to register device: %v", err)
+		}
+	}
+
+	a, err := NewAccessor(ctx, vfsObj, creds, "devtmpfs")
+	if err != nil {
+		t.Fatalf("failed to create devtmpfs.Accessor: %v", err)
+	}
+	defer a.Release(ctx)
+	if err := a.CreateRegisteredDeviceFiles(ctx); err != nil {
+		t.Fatalf("failed to create registered device files: %v", err)
+	}
+
+	for _, f := range []struct {
+		path  string
+		mode  uint16
+		major uint32
+		minor uint32
+	}{
+		{path: "foo/bar", mode: linux.S_IFCHR | 0600, major: 12, minor: 34},
+		{path: "baz", mode: linux.S_IFBLK | 0660, major: 13, minor: 35},
+	} {
+		abspath := path.Join(devPath, f.path)
+		stat, err := vfsObj.StatAt(ctx, creds, &vfs.PathOperation{
+			Root:  root,
+			Start: root,
+			Path:  fspath.Parse(abspath),
+		}, &vfs.StatOptions{
+			Mask: linux.STATX_TYPE | linux.STATX_MODE,
+		})
+		if err != nil {
+			t.Errorf("failed to stat device file at %q: %v", abspath, err)
+			continue
+		}
+		if stat.Mode != f.mode || stat.RdevMajor != f.major || stat.RdevMinor != f.minor {
+			t.Errorf("stat(%q): got mode %#o, device (%d, %d), wanted mode %#o, device (%d, %d)", abspath, stat.Mode, stat.RdevMajor, stat.RdevMinor, f.mode, f.major, f.minor)
+		}
+	}
+}
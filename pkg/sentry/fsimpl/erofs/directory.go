@@ -0,0 +1,159 @@
+// Copyright 2021 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package erofs
+
+import (
+	"gvisor.dev/gvisor/pkg/abi/linux"
+	"gvisor.dev/gvisor/pkg/context"
+	"gvisor.dev/gvisor/pkg/erofs"
+	"gvisor.dev/gvisor/pkg/sentry/vfs"
+	"gvisor.dev/gvisor/pkg/sync"
+	"gvisor.dev/gvisor/pkg/syserror"
+)
+
+// directory represents a directory inode. It holds the directory's entries in
+// memory.
+//
+// +stateify savable
+type directory struct {
+	inode inode
+
+	// childCache maps filenames to dentries for children for which dentries
+	// have been instantiated. childCache is protected by filesystem.mu.
+	childCache map[string]*dentry
+
+	// dirents are the directory's entries, including "." and "..", in the
+	// order in which they are stored in the image. Immutable.
+	dirents []erofs.Dirent `state:"nosave"`
+
+	// childMap maps the child's filename to its entry in dirents. It doesn't
+	// contain "." and "..". Immutable.
+	childMap map[string]*erofs.Dirent `state:"nosave"`
+}
+
+// newDirectory is the directory constructor.
+func newDirectory(fs *filesystem, diskInode *erofs.Inode) (*directory, error) {
+	dirents, err := diskInode.Dirents()
+	if err != nil {
+		return nil, err
+	}
+	file := &directory{
+		childCache: make(map[string]*dentry),
+		dirents:    dirents,
+		childMap:   make(map[string]*erofs.Dirent, len(dirents)),
+	}
+	file.inode.init(fs, diskInode, file)
+	for i := range dirents {
+		if name := dirents[i].Name; name != "." && name != ".." {
+			file.childMap[name] = &dirents[i]
+		}
+	}
+	return file, nil
+}
+
+func (in *inode) isDir() bool {
+	_, ok := in.impl.(*directory)
+	return ok
+}
+
+// direntTypes maps EROFS file types to Linux dirent types.
+var direntTypes = [...]uint8{
+	erofs.FileTypeUnknown:   linux.DT_UNKNOWN,
+	erofs.FileTypeRegular:   linux.DT_REG,
+	erofs.FileTypeDirectory: linux.DT_DIR,
+	erofs.FileTypeCharDev:   linux.DT_CHR,
+	erofs.FileTypeBlockDev:  linux.DT_BLK,
+	erofs.FileTypeFIFO:      linux.DT_FIFO,
+	erofs.FileTypeSocket:    linux.DT_SOCK,
+	erofs.FileTypeSymlink:   linux.DT_LNK,
+}
+
+// directoryFD represents a directory file description. It implements
+// vfs.FileDescriptionImpl.
+//
+// +stateify savable
+type directoryFD struct {
+	fileDescription
+	vfs.DirectoryFileDescriptionDefaultImpl
+
+	// mu protects off.
+	mu sync.Mutex `state:"nosave"`
+
+	// off is the index of the next entry in directory.dirents to be returned.
+	off int64
+}
+
+// Compiles only if directoryFD implements vfs.FileDescriptionImpl.
+var _ vfs.FileDescriptionImpl = (*directoryFD)(nil)
+
+// Release implements vfs.FileDescriptionImpl.Release.
+func (fd *directoryFD) Release(ctx context.Context) {}
+
+// IterDirents implements vfs.FileDescriptionImpl.IterDirents.
+func (fd *directoryFD) IterDirents(ctx context.Context, cb vfs.IterDirentsCallback) error {
+	dir := fd.inode().impl.(*directory)
+
+	fd.mu.Lock()
+	defer fd.mu.Unlock()
+
+	for fd.off < int64(len(dir.dirents)) {
+		child := &dir.dirents[fd.off]
+		var typ uint8
+		if int(child.FileType) < len(direntTypes) {
+			typ = direntTypes[child.FileType]
+		}
+		if typ == linux.DT_UNKNOWN {
+			// Read the inode from the image to get its type. The inode is
+			// not added to the dentry tree.
+			childInode, err := fd.filesystem().image.Inode(child.Nid)
+			if err != nil {
+				return err
+			}
+			typ = linux.FileMode(childInode.Mode).DirentType()
+		}
+		if err := cb.Handle(vfs.Dirent{
+			Name:    child.Name,
+			Type:    typ,
+			Ino:     child.Nid,
+			NextOff: fd.off + 1,
+		}); err != nil {
+			return err
+		}
+		fd.off++
+	}
+	return nil
+}
+
+// Seek implements vfs.FileDescriptionImpl.Seek.
+func (fd *directoryFD) Seek(ctx context.Context, offset int64, whence int32) (int64, error) {
+	fd.mu.Lock()
+	defer fd.mu.Unlock()
+
+	switch whence {
+	case linux.SEEK_SET:
+		// Use offset as specified.
+	case linux.SEEK_CUR:
+		offset += fd.off
+	default:
+		return 0, syserror.EINVAL
+	}
+	if offset < 0 {
+		// lseek(2) specifies that EINVAL should be returned if the resulting offset
+		// is negative.
+		return 0, syserror.EINVAL
+	}
+	fd.off = offset
+	return offset, nil
+}
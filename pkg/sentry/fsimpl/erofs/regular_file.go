@@ -0,0 +1,269 @@
+// Copyright 2021 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package erofs
+
+import (
+	"io"
+
+	"gvisor.dev/gvisor/pkg/abi/linux"
+	"gvisor.dev/gvisor/pkg/context"
+	"gvisor.dev/gvisor/pkg/erofs"
+	"gvisor.dev/gvisor/pkg/safemem"
+	"gvisor.dev/gvisor/pkg/sentry/fs/fsutil"
+	"gvisor.dev/gvisor/pkg/sentry/memmap"
+	"gvisor.dev/gvisor/pkg/sentry/usage"
+	"gvisor.dev/gvisor/pkg/sentry/vfs"
+	"gvisor.dev/gvisor/pkg/sync"
+	"gvisor.dev/gvisor/pkg/syserror"
+	"gvisor.dev/gvisor/pkg/usermem"
+)
+
+// regularFile represents a regular file's inode. This too follows the
+// inheritance pattern prevelant in the vfs layer described in
+// pkg/sentry/vfs/README.md.
+//
+// regularFile implements memmap.Mappable. Memory-mapped data is cached in
+// filesystem.mfp.MemoryFile() while the file has mappings.
+//
+// +stateify savable
+type regularFile struct {
+	inode inode
+
+	// mapsMu protects mappings.
+	mapsMu sync.Mutex `state:"nosave"`
+
+	// mappings tracks mappings of the file into memmap.MappingSpaces.
+	mappings memmap.MappingSet
+
+	// dataMu protects cache.
+	dataMu sync.Mutex `state:"nosave"`
+
+	// cache maps offsets into the file to offsets into
+	// filesystem.mfp.MemoryFile() that store the file's data.
+	cache fsutil.FileRangeSet
+}
+
+// newRegularFile is the regularFile constructor.
+func newRegularFile(fs *filesystem, diskInode *erofs.Inode) *regularFile {
+	file := &regularFile{}
+	file.inode.init(fs, diskInode, file)
+	return file
+}
+
+func (in *inode) isRegular() bool {
+	_, ok := in.impl.(*regularFile)
+	return ok
+}
+
+// dropCache releases the memory caching the file's data.
+func (f *regularFile) dropCache() {
+	f.dataMu.Lock()
+	f.cache.DropAll(f.inode.fs.mfp.MemoryFile())
+	f.dataMu.Unlock()
+}
+
+// readToBlocksAt reads the file's data at offset into dsts.
+func (f *regularFile) readToBlocksAt(ctx context.Context, dsts safemem.BlockSeq, offset uint64) (uint64, error) {
+	if f.inode.diskInode.Compressed() {
+		return 0, syserror.EOPNOTSUPP
+	}
+	r := safemem.FromIOReaderAt{
+		ReaderAt: f.inode.diskInode,
+		Offset:   int64(offset),
+	}
+	return r.ReadToBlocks(dsts)
+}
+
+// regularFileFD represents a regular file description. It implements
+// vfs.FileDescriptionImpl.
+//
+// +stateify savable
+type regularFileFD struct {
+	fileDescription
+
+	// off is the file offset. off is protected by offMu.
+	off int64
+
+	// offMu serializes operations that may mutate off.
+	offMu sync.Mutex `state:"nosave"`
+}
+
+// Release implements vfs.FileDescriptionImpl.Release.
+func (fd *regularFileFD) Release(context.Context) {}
+
+// PRead implements vfs.FileDescriptionImpl.PRead.
+func (fd *regularFileFD) PRead(ctx context.Context, dst usermem.IOSequence, offset int64, opts vfs.ReadOptions) (int64, error) {
+	if offset < 0 {
+		return 0, syserror.EINVAL
+	}
+	diskInode := fd.inode().diskInode
+	if diskInode.Compressed() {
+		return 0, syserror.EOPNOTSUPP
+	}
+	safeReader := safemem.FromIOReaderAt{
+		ReaderAt: diskInode,
+		Offset:   offset,
+	}
+
+	// Copies data from the image directly into usermem without any
+	// intermediate allocations (if dst is converted into BlockSeq such that it
+	// does not need safe copying).
+	return dst.CopyOutFrom(ctx, safeReader)
+}
+
+// Read implements vfs.FileDescriptionImpl.Read.
+func (fd *regularFileFD) Read(ctx context.Context, dst usermem.IOSequence, opts vfs.ReadOptions) (int64, error) {
+	fd.offMu.Lock()
+	n, err := fd.PRead(ctx, dst, fd.off, opts)
+	fd.off += n
+	fd.offMu.Unlock()
+	return n, err
+}
+
+// PWrite implements vfs.FileDescriptionImpl.PWrite.
+func (fd *regularFileFD) PWrite(ctx context.Context, src usermem.IOSequence, offset int64, opts vfs.WriteOptions) (int64, error) {
+	// write(2) specifies that EBADF must be returned if the fd is not open for
+	// writing.
+	return 0, syserror.EBADF
+}
+
+// Write implements vfs.FileDescriptionImpl.Write.
+func (fd *regularFileFD) Write(ctx context.Context, src usermem.IOSequence, opts vfs.WriteOptions) (int64, error) {
+	return 0, syserror.EBADF
+}
+
+// IterDirents implements vfs.FileDescriptionImpl.IterDirents.
+func (fd *regularFileFD) IterDirents(ctx context.Context, cb vfs.IterDirentsCallback) error {
+	return syserror.ENOTDIR
+}
+
+// Seek implements vfs.FileDescriptionImpl.Seek.
+func (fd *regularFileFD) Seek(ctx context.Context, offset int64, whence int32) (int64, error) {
+	fd.offMu.Lock()
+	defer fd.offMu.Unlock()
+	switch whence {
+	case linux.SEEK_SET:
+		// Use offset as specified.
+	case linux.SEEK_CUR:
+		offset += fd.off
+	case linux.SEEK_END:
+		offset += int64(fd.inode().diskInode.Size)
+	default:
+		return 0, syserror.EINVAL
+	}
+	if offset < 0 {
+		return 0, syserror.EINVAL
+	}
+	fd.off = offset
+	return offset, nil
+}
+
+// ConfigureMMap implements vfs.FileDescriptionImpl.ConfigureMMap.
+func (fd *regularFileFD) ConfigureMMap(ctx context.Context, opts *memmap.MMapOpts) error {
+	f := fd.inode().impl.(*regularFile)
+	if f.inode.diskInode.Compressed() {
+		return syserror.ENODEV
+	}
+	return vfs.GenericConfigureMMap(&fd.vfsfd, f, opts)
+}
+
+// AddMapping implements memmap.Mappable.AddMapping.
+func (f *regularFile) AddMapping(ctx context.Context, ms memmap.MappingSpace, ar usermem.AddrRange, offset uint64, writable bool) error {
+	f.mapsMu.Lock()
+	f.mappings.AddMapping(ms, ar, offset, writable)
+	f.mapsMu.Unlock()
+	return nil
+}
+
+// RemoveMapping implements memmap.Mappable.RemoveMapping.
+func (f *regularFile) RemoveMapping(ctx context.Context, ms memmap.MappingSpace, ar usermem.AddrRange, offset uint64, writable bool) {
+	f.mapsMu.Lock()
+	defer f.mapsMu.Unlock()
+	f.mappings.RemoveMapping(ms, ar, offset, writable)
+	if f.mappings.IsEmpty() {
+		// Pages still mapped by application memory hold their own
+		// references, so the cache can be released.
+		f.dropCache()
+	}
+}
+
+// CopyMapping implements memmap.Mappable.CopyMapping.
+func (f *regularFile) CopyMapping(ctx context.Context, ms memmap.MappingSpace, srcAR, dstAR usermem.AddrRange, offset uint64, writable bool) error {
+	return f.AddMapping(ctx, ms, dstAR, offset, writable)
+}
+
+// Translate implements memmap.Mappable.Translate.
+func (f *regularFile) Translate(ctx context.Context, required, optional memmap.MappableRange, at usermem.AccessType) ([]memmap.Translation, error) {
+	// The file is immutable, so its data can't be written through mappings.
+	if at.Write {
+		return nil, &memmap.BusError{syserror.EROFS}
+	}
+
+	size := f.inode.diskInode.Size
+	pgend, _ := usermem.PageRoundUp(size)
+	var beyondEOF bool
+	if required.End > pgend {
+		if required.Start >= pgend {
+			return nil, &memmap.BusError{io.EOF}
+		}
+		beyondEOF = true
+		required.End = pgend
+	}
+	if optional.End > pgend {
+		optional.End = pgend
+	}
+
+	f.dataMu.Lock()
+	defer f.dataMu.Unlock()
+
+	mf := f.inode.fs.mfp.MemoryFile()
+	cerr := f.cache.Fill(ctx, required, optional, size, mf, usage.PageCache, f.readToBlocksAt)
+
+	var ts []memmap.Translation
+	var translatedEnd uint64
+	for seg := f.cache.FindSegment(required.Start); seg.Ok() && seg.Start() < required.End; seg, _ = seg.NextNonEmpty() {
+		segMR := seg.Range().Intersect(optional)
+		ts = append(ts, memmap.Translation{
+			Source: segMR,
+			File:   mf,
+			Offset: seg.FileRangeOf(segMR).Start,
+			Perms: usermem.AccessType{
+				Read:    true,
+				Execute: true,
+			},
+		})
+		translatedEnd = segMR.End
+	}
+
+	// Don't return the error returned by f.cache.Fill if it occurred outside
+	// of required.
+	if translatedEnd < required.End && cerr != nil {
+		return ts, &memmap.BusError{cerr}
+	}
+	if beyondEOF {
+		return ts, &memmap.BusError{io.EOF}
+	}
+	return ts, nil
+}
+
+// InvalidateUnsavable implements memmap.Mappable.InvalidateUnsavable.
+func (f *regularFile) InvalidateUnsavable(ctx context.Context) error {
+	// The image isn't saved, so the cache must be repopulated after restore.
+	f.mapsMu.Lock()
+	defer f.mapsMu.Unlock()
+	f.mappings.InvalidateAll(memmap.InvalidateOpts{})
+	f.dropCache()
+	return nil
+}
@@ -16,6 +16,8 @@
 package eventfd
 
 import (
+	"bytes"
+	"fmt"
 	"math"
 	"sync"
 	"syscall"
@@ -121,6 +123,17 @@ func (efd *EventFileDescription) Release(context.Context) {
 	}
 }
 
+// FDInfo implements vfs.FileDescriptionImplFDInfoExtension.FDInfo.
+func (efd *EventFileDescription) FDInfo(ctx context.Context, buf *bytes.Buffer) {
+	efd.mu.Lock()
+	defer efd.mu.Unlock()
+	if efd.hostfd >= 0 {
+		// The counter of host eventfds can't be read without consuming it.
+		return
+	}
+	fmt.Fprintf(buf, "eventfd-count: %16x\n", efd.val)
+}
+
 // Read implements vfs.FileDescriptionImpl.Read.
 func (efd *EventFileDescription) Read(ctx context.Context, dst usermem.IOSequence, _ vfs.ReadOptions) (int64, error) {
 	if dst.NumBytes() < 8 {
@@ -15,6 +15,7 @@
 package eventfd
 
 import (
+	"bytes"
 	"testing"
 
 	"gvisor.dev/gvisor/pkg/abi/linux"
@@ -95,3 +96,23 @@ func TestEventFDStat(t *testing.T) {
 		t.Errorf("eventfd size should be 0")
 	}
 }
+
+func TestEventFDInfo(t *testing.T) {
+	ctx := contexttest.Context(t)
+	vfsObj := &vfs.VirtualFilesystem{}
+	if err := vfsObj.Init(ctx); err != nil {
+		t.Fatalf("VFS init: %v", err)
+	}
+
+	eventfd, err := New(ctx, vfsObj, 0x2a, false, linux.O_RDWR)
+	if err != nil {
+		t.Fatalf("New() failed: %v", err)
+	}
+	defer eventfd.DecRef(ctx)
+
+	var buf bytes.Buffer
+	eventfd.Impl().(vfs.FileDescriptionImplFDInfoExtension).FDInfo(ctx, &buf)
+	if got, want := buf.String(), "eventfd-count:               2a\n"; got != want {
+		t.Errorf("FDInfo: got %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,423 @@
+// Copyright 2021 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ext
+
+import (
+	"encoding/binary"
+
+	"gvisor.dev/gvisor/pkg/log"
+	"gvisor.dev/gvisor/pkg/sentry/fsimpl/ext/disklayout"
+	"gvisor.dev/gvisor/pkg/syserror"
+)
+
+// Blocks and inodes are allocated from the block and inode bitmaps of block
+// groups. Bitmaps are always written before the group descriptors and the
+// metadata referring to the allocated blocks and inodes, and freed blocks and
+// inodes are only released after all references to them were written. A crash
+// can then only leak blocks and inodes, which e2fsck reclaims, but never cause
+// them to be used twice.
+
+// bitSet returns true if bit i of bitmap is set.
+func bitSet(bitmap []byte, i uint64) bool {
+	return bitmap[i/8]&(1<<(i%8)) != 0
+}
+
+// setBit sets bit i of bitmap.
+func setBit(bitmap []byte, i uint64) {
+	bitmap[i/8] |= 1 << (i % 8)
+}
+
+// clearBit clears bit i of bitmap.
+func clearBit(bitmap []byte, i uint64) {
+	bitmap[i/8] &^= 1 << (i % 8)
+}
+
+// groupFirstBlock returns the first block of block group bgNum.
+func (fs *filesystem) groupFirstBlock(bgNum uint32) uint64 {
+	return uint64(fs.sb.FirstDataBlock()) + uint64(bgNum)*uint64(fs.sb.BlocksPerGroup())
+}
+
+// groupBlocksCount returns the number of blocks in block group bgNum. Only the
+// last group may have less than BlocksPerGroup() blocks.
+func (fs *filesystem) groupBlocksCount(bgNum uint32) uint64 {
+	if n := fs.sb.BlocksCount() - fs.groupFirstBlock(bgNum); n < uint64(fs.sb.BlocksPerGroup()) {
+		return n
+	}
+	return uint64(fs.sb.BlocksPerGroup())
+}
+
+// blockGroupOf returns the block group containing blk and the index of blk in
+// the group.
+func (fs *filesystem) blockGroupOf(blk uint64) (uint32, uint64) {
+	rel := blk - uint64(fs.sb.FirstDataBlock())
+	return uint32(rel / uint64(fs.sb.BlocksPerGroup())), rel % uint64(fs.sb.BlocksPerGroup())
+}
+
+// groupHasSuper returns true if block group bgNum holds a copy of the
+// superblock and the group descriptor table.
+//
+// groupHasSuper is analogous to Linux's fs/ext4/balloc.c:ext4_bg_has_super().
+func (fs *filesystem) groupHasSuper(bgNum uint32) bool {
+	if bgNum == 0 {
+		return true
+	}
+	if binary.LittleEndian.Uint32(fs.sbRaw[sbFeatureCompatOff:])&disklayout.SbSparseV2 != 0 {
+		return bgNum == binary.LittleEndian.Uint32(fs.sbRaw[sbBackupBgsOff:]) ||
+			bgNum == binary.LittleEndian.Uint32(fs.sbRaw[sbBackupBgsOff+4:])
+	}
+	if bgNum == 1 || !fs.sb.ReadOnlyCompatibleFeatures().Sparse {
+		return true
+	}
+	if bgNum%2 == 0 {
+		return false
+	}
+	for _, base := range []uint32{3, 5, 7} {
+		n := base
+		for n < bgNum {
+			n *= base
+		}
+		if n == bgNum {
+			return true
+		}
+	}
+	return false
+}
+
+// initBlockBitmap initializes the block bitmap of block group bgNum, which
+// has the BlockUninit flag: only the group's own metadata blocks are in use.
+//
+// initBlockBitmap is analogous to Linux's
+// fs/ext4/balloc.c:ext4_init_block_bitmap().
+func (fs *filesystem) initBlockBitmap(bgNum uint32, bitmap []byte) {
+	for i := range bitmap {
+		bitmap[i] = 0
+	}
+	if fs.groupHasSuper(bgNum) {
+		gdtBlocks := (uint64(len(fs.bgs))*uint64(fs.sb.BgDescSize()) + fs.sb.BlockSize() - 1) / fs.sb.BlockSize()
+		reservedGdtBlocks := uint64(binary.LittleEndian.Uint16(fs.sbRaw[sbReservedGdtBlocksOff:]))
+		for i := uint64(0); i < 1+gdtBlocks+reservedGdtBlocks; i++ {
+			setBit(bitmap, i)
+		}
+	}
+
+	// Mark the group's bitmaps and inode table if they are in the group,
+	// which they may not be with flexible block groups.
+	first := fs.groupFirstBlock(bgNum)
+	count := fs.groupBlocksCount(bgNum)
+	markInGroup := func(blk, n uint64) {
+		for ; n > 0; blk, n = blk+1, n-1 {
+			if blk >= first && blk < first+count {
+				setBit(bitmap, blk-first)
+			}
+		}
+	}
+	bg := fs.bgs[bgNum]
+	markInGroup(bg.BlockBitmap(), 1)
+	markInGroup(bg.InodeBitmap(), 1)
+	inodeTableBlocks := (uint64(fs.sb.InodesPerGroup())*uint64(fs.sb.InodeSize()) + fs.sb.BlockSize() - 1) / fs.sb.BlockSize()
+	markInGroup(bg.InodeTable(), inodeTableBlocks)
+
+	// Blocks past the end of the group are always in use.
+	for i := count; i < uint64(len(bitmap))*8; i++ {
+		setBit(bitmap, i)
+	}
+}
+
+// readBlockBitmapLocked returns the block bitmap of block group bgNum.
+//
+// Preconditions: fs.allocMu must be locked.
+func (fs *filesystem) readBlockBitmapLocked(bgNum uint32) ([]byte, error) {
+	bg := fs.bgs[bgNum]
+	if fs.uninitBG && bg.Flags().BlockUninit {
+		bitmap := make([]byte, fs.sb.BlockSize())
+		fs.initBlockBitmap(bgNum, bitmap)
+		return bitmap, nil
+	}
+	return fs.readBlock(bg.BlockBitmap())
+}
+
+// writeBlockBitmapLocked writes the block bitmap of block group bgNum. The
+// group descriptor must be written afterwards.
+//
+// Preconditions: fs.allocMu must be locked.
+func (fs *filesystem) writeBlockBitmapLocked(bgNum uint32, bitmap []byte) error {
+	bg := fs.bgs[bgNum]
+	if err := fs.writeBlock(bg.BlockBitmap(), bitmap); err != nil {
+		return err
+	}
+	if fs.metadataCsum {
+		bg.SetBlockBitmapChecksum(fs.bitmapChecksum(bitmap, fs.sb.ClustersPerGroup()))
+	}
+	flags := bg.Flags()
+	flags.BlockUninit = false
+	bg.SetFlags(flags)
+	return nil
+}
+
+// readInodeBitmapLocked returns the inode bitmap of block group bgNum.
+//
+// Preconditions: fs.allocMu must be locked.
+func (fs *filesystem) readInodeBitmapLocked(bgNum uint32) ([]byte, error) {
+	bg := fs.bgs[bgNum]
+	if fs.uninitBG && bg.Flags().InodeUninit {
+		bitmap := make([]byte, fs.sb.BlockSize())
+		for i := uint64(fs.sb.InodesPerGroup()); i < uint64(len(bitmap))*8; i++ {
+			setBit(bitmap, i)
+		}
+		return bitmap, nil
+	}
+	return fs.readBlock(bg.InodeBitmap())
+}
+
+// writeInodeBitmapLocked writes the inode bitmap of block group bgNum. The
+// group descriptor must be written afterwards.
+//
+// Preconditions: fs.allocMu must be locked.
+func (fs *filesystem) writeInodeBitmapLocked(bgNum uint32, bitmap []byte) error {
+	bg := fs.bgs[bgNum]
+	if err := fs.writeBlock(bg.InodeBitmap(), bitmap); err != nil {
+		return err
+	}
+	if fs.metadataCsum {
+		bg.SetInodeBitmapChecksum(fs.bitmapChecksum(bitmap, fs.sb.InodesPerGroup()))
+	}
+	flags := bg.Flags()
+	flags.InodeUninit = false
+	bg.SetFlags(flags)
+	return nil
+}
+
+// writeGroupDescLocked writes the descriptor of block group bgNum to the
+// group descriptor table. Backup copies of the table are not updated, like in
+// Linux.
+//
+// Preconditions: fs.allocMu must be locked.
+func (fs *filesystem) writeGroupDescLocked(bgNum uint32) error {
+	bg := fs.bgs[bgNum]
+	buf := make([]byte, bg.SizeBytes())
+	if fs.uninitBG {
+		bg.SetChecksum(0)
+		bg.MarshalBytes(buf)
+		bg.SetChecksum(fs.groupDescChecksum(bgNum, buf))
+	}
+	bg.MarshalBytes(buf)
+	off := uint64(fs.sb.FirstDataBlock()+1)*fs.sb.BlockSize() + uint64(bgNum)*uint64(fs.sb.BgDescSize())
+	return fs.writeAt(buf, int64(off))
+}
+
+// allocBlocks allocates up to n contiguous blocks, preferably starting at
+// goal. It returns the first allocated block and the number of allocated
+// blocks, which is at least 1.
+func (fs *filesystem) allocBlocks(goal, n uint64) (uint64, uint64, error) {
+	fs.allocMu.Lock()
+	defer fs.allocMu.Unlock()
+	if fs.freeBlocksCount == 0 {
+		return 0, 0, syserror.ENOSPC
+	}
+	if goal < uint64(fs.sb.FirstDataBlock()) || goal >= fs.sb.BlocksCount() {
+		goal = uint64(fs.sb.FirstDataBlock())
+	}
+	goalBG, goalIdx := fs.blockGroupOf(goal)
+	bgCount := uint32(len(fs.bgs))
+	for i := uint32(0); i <= bgCount; i++ {
+		bgNum := (goalBG + i) % bgCount
+		bg := fs.bgs[bgNum]
+		if bg.FreeBlocksCount() == 0 {
+			continue
+		}
+		bitmap, err := fs.readBlockBitmapLocked(bgNum)
+		if err != nil {
+			return 0, 0, err
+		}
+
+		// Search from the goal in its group, and from the start of the group
+		// in other groups and when wrapping around to the goal's group.
+		from := uint64(0)
+		if i == 0 {
+			from = goalIdx
+		}
+		count := fs.groupBlocksCount(bgNum)
+		idx := from
+		for idx < count && bitSet(bitmap, idx) {
+			idx++
+		}
+		if idx == count {
+			continue
+		}
+		run := uint64(0)
+		for run < n && idx+run < count && !bitSet(bitmap, idx+run) {
+			setBit(bitmap, idx+run)
+			run++
+		}
+
+		if err := fs.writeBlockBitmapLocked(bgNum, bitmap); err != nil {
+			return 0, 0, err
+		}
+		bg.SetFreeBlocksCount(bg.FreeBlocksCount() - uint32(run))
+		if err := fs.writeGroupDescLocked(bgNum); err != nil {
+			return 0, 0, err
+		}
+		fs.freeBlocksCount -= run
+		return fs.groupFirstBlock(bgNum) + idx, run, nil
+	}
+	return 0, 0, syserror.ENOSPC
+}
+
+// freeBlocks frees the n blocks starting at blk.
+func (fs *filesystem) freeBlocks(blk, n uint64) error {
+	fs.allocMu.Lock()
+	defer fs.allocMu.Unlock()
+	for n > 0 {
+		bgNum, idx := fs.blockGroupOf(blk)
+		count := fs.groupBlocksCount(bgNum) - idx
+		if count > n {
+			count = n
+		}
+		bitmap, err := fs.readBlockBitmapLocked(bgNum)
+		if err != nil {
+			return err
+		}
+		var freed uint32
+		for i := idx; i < idx+count; i++ {
+			if !bitSet(bitmap, i) {
+				log.Warningf("ext fs: freeing free block %d", fs.groupFirstBlock(bgNum)+i)
+				continue
+			}
+			clearBit(bitmap, i)
+			freed++
+		}
+		if err := fs.writeBlockBitmapLocked(bgNum, bitmap); err != nil {
+			return err
+		}
+		bg := fs.bgs[bgNum]
+		bg.SetFreeBlocksCount(bg.FreeBlocksCount() + freed)
+		if err := fs.writeGroupDescLocked(bgNum); err != nil {
+			return err
+		}
+		fs.freeBlocksCount += uint64(freed)
+		blk += count
+		n -= count
+	}
+	return nil
+}
+
+// allocInode allocates an inode, preferably in block group bgNum, and returns
+// its number. Directories are spread across block groups instead.
+func (fs *filesystem) allocInode(bgNum uint32, isDir bool) (uint32, error) {
+	fs.allocMu.Lock()
+	defer fs.allocMu.Unlock()
+	if fs.freeInodesCount == 0 {
+		return 0, syserror.ENOSPC
+	}
+	inodesPerGrp := fs.sb.InodesPerGroup()
+	firstInode := binary.LittleEndian.Uint32(fs.sbRaw[sbFirstInodeOff:])
+	bgCount := uint32(len(fs.bgs))
+	if isDir {
+		bgNum = fs.dirGroupLocked(bgNum)
+	}
+	for i := uint32(0); i < bgCount; i++ {
+		curBG := (bgNum + i) % bgCount
+		bg := fs.bgs[curBG]
+		if bg.FreeInodesCount() == 0 {
+			continue
+		}
+		bitmap, err := fs.readInodeBitmapLocked(curBG)
+		if err != nil {
+			return 0, err
+		}
+		for idx := uint32(0); idx < inodesPerGrp; idx++ {
+			inodeNum := curBG*inodesPerGrp + idx + 1
+			if bitSet(bitmap, uint64(idx)) || inodeNum < firstInode {
+				continue
+			}
+
+			setBit(bitmap, uint64(idx))
+			if err := fs.writeInodeBitmapLocked(curBG, bitmap); err != nil {
+				return 0, err
+			}
+			bg.SetFreeInodesCount(bg.FreeInodesCount() - 1)
+			if isDir {
+				bg.SetDirectoryCount(bg.DirectoryCount() + 1)
+			}
+			if fs.uninitBG {
+				// Inodes past the used part of the inode table are not even
+				// checked by e2fsck, so the allocated inode must be included.
+				if used := inodesPerGrp - bg.UnusedInodeCount(); idx+1 > used {
+					bg.SetUnusedInodeCount(inodesPerGrp - (idx + 1))
+				}
+			}
+			if err := fs.writeGroupDescLocked(curBG); err != nil {
+				return 0, err
+			}
+			fs.freeInodesCount--
+			return inodeNum, nil
+		}
+	}
+	return 0, syserror.ENOSPC
+}
+
+// dirGroupLocked returns the block group in which to allocate a directory
+// whose parent is in group parentBG. Like Linux's find_group_orlov, it picks
+// the group with the fewest directories among those with at least the average
+// number of free inodes, so that directory trees are spread across the disk.
+//
+// Preconditions: fs.allocMu must be locked.
+func (fs *filesystem) dirGroupLocked(parentBG uint32) uint32 {
+	bgCount := uint32(len(fs.bgs))
+	avgFreeInodes := fs.freeInodesCount / bgCount
+	best, bestDirs := parentBG, ^uint32(0)
+	for i := uint32(0); i < bgCount; i++ {
+		curBG := (parentBG + i) % bgCount
+		bg := fs.bgs[curBG]
+		if bg.FreeInodesCount() == 0 || bg.FreeInodesCount() < avgFreeInodes {
+			continue
+		}
+		if dirs := bg.DirectoryCount(); dirs < bestDirs {
+			best, bestDirs = curBG, dirs
+		}
+	}
+	return best
+}
+
+// freeInode frees the inode with the given number.
+func (fs *filesystem) freeInode(inodeNum uint32, isDir bool) error {
+	fs.allocMu.Lock()
+	defer fs.allocMu.Unlock()
+	inodesPerGrp := fs.sb.InodesPerGroup()
+	bgNum := getBGNum(inodeNum, inodesPerGrp)
+	idx := uint64(getBGOff(inodeNum, inodesPerGrp))
+	bitmap, err := fs.readInodeBitmapLocked(bgNum)
+	if err != nil {
+		return err
+	}
+	if !bitSet(bitmap, idx) {
+		log.Warningf("ext fs: freeing free inode %d", inodeNum)
+		return nil
+	}
+	clearBit(bitmap, idx)
+	if err := fs.writeInodeBitmapLocked(bgNum, bitmap); err != nil {
+		return err
+	}
+	bg := fs.bgs[bgNum]
+	bg.SetFreeInodesCount(bg.FreeInodesCount() + 1)
+	if isDir {
+		bg.SetDirectoryCount(bg.DirectoryCount() - 1)
+	}
+	if err := fs.writeGroupDescLocked(bgNum); err != nil {
+		return err
+	}
+	fs.freeInodesCount++
+	return nil
+}
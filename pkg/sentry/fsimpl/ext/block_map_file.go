@@ -15,6 +15,7 @@
 package ext
 
 import (
+	"encoding/binary"
 	"io"
 	"math"
 
@@ -201,3 +202,74 @@ func (f *blockMapFile) read(curPhyBlk uint32, relFileOff uint64, height uint, ds
 func getCoverage(blkSize uint64, height uint) uint64 {
 	return blkSize * uint64(math.Pow(float64(blkSize/4), float64(height)))
 }
+
+// truncateLocked sets the size of the file. Block map files can only grow, or
+// shrink to 0, which frees all of their blocks.
+//
+// Preconditions: inode.mu must be locked for writing.
+func (f *blockMapFile) truncateLocked(size uint64) error {
+	in := &f.regFile.inode
+	if size >= in.diskInode.Size() {
+		in.diskInode.SetSize(size)
+		return in.writeBackLocked()
+	}
+	if size != 0 {
+		return syserror.EOPNOTSUPP
+	}
+
+	var freed []blockRange
+	for _, blk := range f.directBlks {
+		if blk != 0 {
+			freed = append(freed, blockRange{uint64(blk), 1})
+		}
+	}
+	for height, blk := range []primitive.Uint32{f.indirectBlk, f.doubleIndirectBlk, f.tripleIndirectBlk} {
+		if blk != 0 {
+			if err := f.collectBlocks(uint32(blk), uint(height+1), &freed); err != nil {
+				return err
+			}
+		}
+	}
+
+	f.directBlks = [numDirectBlks]primitive.Uint32{}
+	f.indirectBlk, f.doubleIndirectBlk, f.tripleIndirectBlk = 0, 0, 0
+	blkMap := in.diskInode.Data()
+	for i := range blkMap {
+		blkMap[i] = 0
+	}
+	in.diskInode.SetSize(0)
+	in.diskInode.SetBlocksCount(0)
+	if err := in.writeBackLocked(); err != nil {
+		return err
+	}
+	for _, r := range freed {
+		if err := in.fs.freeBlocks(r.start, r.count); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// collectBlocks appends the indirect block blk of the given height and all
+// the blocks it points to to blocks.
+func (f *blockMapFile) collectBlocks(blk uint32, height uint, blocks *[]blockRange) error {
+	buf, err := f.regFile.inode.fs.readBlock(uint64(blk))
+	if err != nil {
+		return err
+	}
+	for off := 0; off < len(buf); off += 4 {
+		child := binary.LittleEndian.Uint32(buf[off:])
+		if child == 0 {
+			continue
+		}
+		if height > 1 {
+			if err := f.collectBlocks(child, height-1, blocks); err != nil {
+				return err
+			}
+		} else {
+			*blocks = append(*blocks, blockRange{uint64(child), 1})
+		}
+	}
+	*blocks = append(*blocks, blockRange{uint64(blk), 1})
+	return nil
+}
@@ -0,0 +1,107 @@
+// Copyright 2021 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ext
+
+import (
+	"encoding/binary"
+	"hash/crc32"
+
+	"gvisor.dev/gvisor/pkg/sentry/fsimpl/ext/disklayout"
+)
+
+// Metadata checksums are computed as in Linux's fs/ext4: with the
+// metadata_csum feature, all metadata is checksummed with CRC32C. With the
+// older gdt_csum feature, only group descriptors are checksummed, with CRC16.
+//
+// See https://www.kernel.org/doc/html/latest/filesystems/ext4/overview.html#checksums.
+
+var castagnoliTable = crc32.MakeTable(crc32.Castagnoli)
+
+// crc32c continues the CRC32C checksum crc with b. Unlike hash/crc32, ext4
+// neither inverts the seed nor the result.
+func crc32c(crc uint32, b []byte) uint32 {
+	return ^crc32.Update(^crc, castagnoliTable, b)
+}
+
+// crc32cUint32 continues the CRC32C checksum crc with the little-endian
+// representation of v.
+func crc32cUint32(crc uint32, v uint32) uint32 {
+	var b [4]byte
+	binary.LittleEndian.PutUint32(b[:], v)
+	return crc32c(crc, b[:])
+}
+
+// crc16 continues the CRC16 (polynomial 0x8005, reflected) checksum crc with
+// b, as implemented in Linux by lib/crc16.c.
+func crc16(crc uint16, b []byte) uint16 {
+	for _, c := range b {
+		crc ^= uint16(c)
+		for i := 0; i < 8; i++ {
+			if crc&1 != 0 {
+				crc = (crc >> 1) ^ 0xa001
+			} else {
+				crc >>= 1
+			}
+		}
+	}
+	return crc
+}
+
+// checksumSeed returns the seed of all metadata checksums of the filesystem
+// whose raw superblock is sbRaw.
+func checksumSeed(sbRaw []byte) uint32 {
+	if binary.LittleEndian.Uint32(sbRaw[sbFeatureIncompatOff:])&disklayout.SbCsumSeed != 0 {
+		return binary.LittleEndian.Uint32(sbRaw[sbChecksumSeedOff:])
+	}
+	return crc32c(^uint32(0), sbRaw[sbUUIDOff:sbUUIDOff+16])
+}
+
+// superBlockChecksum returns the checksum of the raw superblock sbRaw.
+func superBlockChecksum(sbRaw []byte) uint32 {
+	return crc32c(^uint32(0), sbRaw[:sbChecksumOff])
+}
+
+// inodeChecksumSeed returns the seed of the checksums of the metadata blocks
+// belonging to the inode with the given number and generation.
+func (fs *filesystem) inodeChecksumSeed(inodeNum, generation uint32) uint32 {
+	return crc32cUint32(crc32cUint32(fs.csumSeed, inodeNum), generation)
+}
+
+// inodeChecksum returns the checksum of the raw inode record buf, whose
+// checksum fields must be zeroed.
+func (fs *filesystem) inodeChecksum(inodeNum, generation uint32, buf []byte) uint32 {
+	return crc32c(fs.inodeChecksumSeed(inodeNum, generation), buf)
+}
+
+// groupDescChecksum returns the checksum of the raw group descriptor buf of
+// group bgNum, whose checksum field must be zeroed.
+func (fs *filesystem) groupDescChecksum(bgNum uint32, buf []byte) uint16 {
+	if fs.metadataCsum {
+		return uint16(crc32c(crc32cUint32(fs.csumSeed, bgNum), buf))
+	}
+	// The CRC16 checksum skips the checksum field instead of zeroing it.
+	var le [4]byte
+	binary.LittleEndian.PutUint32(le[:], bgNum)
+	crc := crc16(^uint16(0), fs.sbRaw[sbUUIDOff:sbUUIDOff+16])
+	crc = crc16(crc, le[:])
+	crc = crc16(crc, buf[:bgChecksumOff])
+	return crc16(crc, buf[bgChecksumOff+2:])
+}
+
+// bitmapChecksum returns the checksum of a block or inode bitmap, of which
+// only the first n bits are checksummed.
+func (fs *filesystem) bitmapChecksum(bitmap []byte, n uint32) uint32 {
+	return crc32c(fs.csumSeed, bitmap[:n/8])
+}
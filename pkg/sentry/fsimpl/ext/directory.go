@@ -15,6 +15,9 @@
 package ext
 
 import (
+	"encoding/binary"
+	"io"
+
 	"gvisor.dev/gvisor/pkg/abi/linux"
 	"gvisor.dev/gvisor/pkg/context"
 	"gvisor.dev/gvisor/pkg/log"
@@ -35,10 +38,15 @@ type directory struct {
 	// have been instantiated. childCache is protected by filesystem.mu.
 	childCache map[string]*dentry
 
-	// mu serializes the changes to childList.
+	// mu serializes the changes to childList and childMap.
+	//
 	// Lock Order (outermost locks must be taken first):
-	//   directory.mu
-	//     filesystem.mu
+	//   filesystem.mu
+	//     directory.mu
+	//
+	// directoryFD.IterDirents locks filesystem.mu while holding directory.mu
+	// when dirents lack file types. This does not conflict with the order
+	// above, since such filesystems are read-only.
 	mu sync.Mutex `state:"nosave"`
 
 	// childList is a list containing (1) child dirents and (2) fake dirents
@@ -50,8 +58,12 @@ type directory struct {
 	// childMap maps the child's filename to the dirent structure stored in
 	// childList. This adds some data replication but helps in faster path
 	// traversal. For consistency, key == childMap[key].diskDirent.FileName().
-	// Immutable.
+	// childMap is protected by both filesystem.mu and mu: holding either is
+	// sufficient for reading it, and both must be locked to modify it.
 	childMap map[string]*dirent
+
+	// data holds the dirents on disk. Immutable.
+	data *regularFile
 }
 
 // newDirectory is the directory constructor.
@@ -62,6 +74,13 @@ func newDirectory(args inodeArgs, newDirent bool) (*directory, error) {
 	}
 	file.inode.init(args, file)
 
+	// The dirents are organized in a linear array in the file data.
+	regFile, err := newRegularFile(args)
+	if err != nil {
+		return nil, err
+	}
+	file.data = regFile
+
 	// Initialize childList by reading dirents from the underlying file.
 	if args.diskInode.Flags().Index {
 		// TODO(b/134676337): Support hash tree directories. Currently only the '.'
@@ -72,13 +91,6 @@ func newDirectory(args inodeArgs, newDirent bool) (*directory, error) {
 		return file, nil
 	}
 
-	// The dirents are organized in a linear array in the file data.
-	// Extract the file data and decode the dirents.
-	regFile, err := newRegularFile(args)
-	if err != nil {
-		return nil, err
-	}
-
 	// buf is used as scratch space for reading in dirents from disk and
 	// unmarshalling them into dirent structs.
 	buf := make([]byte, disklayout.DirentSize)
@@ -99,6 +111,7 @@ func newDirectory(args inodeArgs, newDirent bool) (*directory, error) {
 			curDirent.diskDirent = &disklayout.DirentOld{}
 		}
 		curDirent.diskDirent.UnmarshalBytes(buf)
+		curDirent.off = off
 
 		if curDirent.diskDirent.Inode() != 0 && len(curDirent.diskDirent.FileName()) != 0 {
 			// Inode number and name length fields being set to 0 is used to indicate
@@ -119,12 +132,268 @@ func (i *inode) isDir() bool {
 	return ok
 }
 
+// putDirent serializes a dirent in buf.
+func putDirent(buf []byte, inodeNum uint32, recLen uint16, name string, fileType uint8) {
+	binary.LittleEndian.PutUint32(buf, inodeNum)
+	binary.LittleEndian.PutUint16(buf[4:], recLen)
+	buf[6] = uint8(len(name))
+	buf[7] = fileType
+	copy(buf[disklayout.DirentHeaderSize:], name)
+}
+
+// usableBlockSize returns the size of the part of directory blocks which
+// holds dirents.
+func (dir *directory) usableBlockSize() uint64 {
+	if dir.inode.fs.metadataCsum {
+		return dir.inode.blkSize - disklayout.DirentTailSize
+	}
+	return dir.inode.blkSize
+}
+
+// readBlockLocked reads the directory block at offset off in the directory
+// data.
+//
+// Preconditions: inode.mu must be locked.
+func (dir *directory) readBlockLocked(off uint64) ([]byte, error) {
+	buf := make([]byte, dir.inode.blkSize)
+	if n, err := dir.data.impl.ReadAt(buf, int64(off)); n < len(buf) {
+		if err == nil || err == io.EOF {
+			err = syserror.EIO
+		}
+		return nil, err
+	}
+	return buf, nil
+}
+
+// writeBlockLocked writes the directory block buf at offset off in the
+// directory data, after updating the checksum in its tail.
+//
+// Preconditions: inode.mu must be locked for writing.
+func (dir *directory) writeBlockLocked(ctx context.Context, buf []byte, off uint64) error {
+	in := &dir.inode
+	if in.fs.metadataCsum {
+		tail := buf[dir.usableBlockSize():]
+		putDirent(tail, 0, disklayout.DirentTailSize, "", disklayout.DirentTailFileType)
+		seed := in.fs.inodeChecksumSeed(in.inodeNum, in.diskInode.Generation())
+		binary.LittleEndian.PutUint32(tail[8:], crc32c(seed, buf[:dir.usableBlockSize()]))
+	}
+	_, err := dir.data.writeAtLocked(ctx, buf, off)
+	return err
+}
+
+// hasTail returns true if the directory block buf ends with a checksum tail.
+func (dir *directory) hasTail(buf []byte) bool {
+	tail := buf[dir.usableBlockSize():]
+	return binary.LittleEndian.Uint32(tail) == 0 &&
+		binary.LittleEndian.Uint16(tail[4:]) == disklayout.DirentTailSize &&
+		tail[6] == 0 && tail[7] == disklayout.DirentTailFileType
+}
+
+// checkWritable returns an error if the dirents of the directory can not be
+// modified.
+func (dir *directory) checkWritable() error {
+	if dir.inode.diskInode.Flags().Index {
+		// TODO(b/134676337): Support hash tree directories.
+		return syserror.EOPNOTSUPP
+	}
+	return nil
+}
+
+// addEntry adds a dirent with the given name, inode number and file type to
+// the directory, in the first free space large enough to hold it. A new block
+// is appended if there is none.
+//
+// Preconditions: filesystem.mu must be locked for writing.
+func (dir *directory) addEntry(ctx context.Context, name string, inodeNum uint32, fileType uint8) error {
+	dir.mu.Lock()
+	defer dir.mu.Unlock()
+	in := &dir.inode
+	in.mu.Lock()
+	defer in.mu.Unlock()
+	if err := dir.checkWritable(); err != nil {
+		return err
+	}
+
+	need := disklayout.DirentRecordSize(len(name))
+	usable := dir.usableBlockSize()
+	size := in.diskInode.Size()
+	var (
+		buf    []byte
+		blkOff uint64
+		recOff uint64
+		recLen uint16
+		found  bool
+	)
+	for blkOff = 0; blkOff < size && !found; blkOff += in.blkSize {
+		var err error
+		if buf, err = dir.readBlockLocked(blkOff); err != nil {
+			return err
+		}
+		if in.fs.metadataCsum && !dir.hasTail(buf) {
+			// There is no room for the checksum of this block.
+			continue
+		}
+		for off := uint64(0); off < usable; {
+			curLen := binary.LittleEndian.Uint16(buf[off+4:])
+			if curLen < disklayout.DirentHeaderSize || off+uint64(curLen) > usable {
+				log.Warningf("ext fs: corrupted dirent at offset %d of directory inode %d", blkOff+off, in.inodeNum)
+				return syserror.EIO
+			}
+			used := uint16(0)
+			if binary.LittleEndian.Uint32(buf[off:]) != 0 {
+				used = disklayout.DirentRecordSize(int(buf[off+6]))
+			}
+			if curLen-used >= need {
+				if used != 0 {
+					// Split the record.
+					binary.LittleEndian.PutUint16(buf[off+4:], used)
+				}
+				recOff, recLen, found = off+uint64(used), curLen-used, true
+				break
+			}
+			off += uint64(curLen)
+		}
+	}
+	if found {
+		blkOff -= in.blkSize
+	} else {
+		// Append a new block.
+		blkOff = size
+		buf = make([]byte, in.blkSize)
+		recOff, recLen = 0, uint16(usable)
+	}
+
+	putDirent(buf[recOff:], inodeNum, recLen, name, fileType)
+	if err := dir.writeBlockLocked(ctx, buf, blkOff); err != nil {
+		return err
+	}
+
+	d := &dirent{
+		diskDirent: newDiskDirent(name, inodeNum, recLen, fileType),
+		off:        blkOff + recOff,
+	}
+	dir.childList.PushBack(d)
+	dir.childMap[name] = d
+	return nil
+}
+
+// newDiskDirent returns the in-memory representation of a dirent.
+func newDiskDirent(name string, inodeNum uint32, recLen uint16, fileType uint8) *disklayout.DirentNew {
+	diskDirent := &disklayout.DirentNew{
+		InodeNumber:  inodeNum,
+		RecordLength: recLen,
+		NameLength:   uint8(len(name)),
+		FileTypeRaw:  fileType,
+	}
+	copy(diskDirent.FileNameRaw[:], name)
+	return diskDirent
+}
+
+// removeEntry removes the dirent with the given name from the directory. Its
+// record is merged into the preceding record of the block, or marked as
+// unused if it is the first one.
+//
+// Preconditions: filesystem.mu must be locked for writing.
+func (dir *directory) removeEntry(ctx context.Context, name string) error {
+	dir.mu.Lock()
+	defer dir.mu.Unlock()
+	in := &dir.inode
+	in.mu.Lock()
+	defer in.mu.Unlock()
+	if err := dir.checkWritable(); err != nil {
+		return err
+	}
+	d, ok := dir.childMap[name]
+	if !ok {
+		return syserror.ENOENT
+	}
+
+	blkOff := d.off - d.off%in.blkSize
+	buf, err := dir.readBlockLocked(blkOff)
+	if err != nil {
+		return err
+	}
+	target := d.off - blkOff
+	prev := int64(-1)
+	for off := uint64(0); off < target; {
+		curLen := binary.LittleEndian.Uint16(buf[off+4:])
+		if curLen < disklayout.DirentHeaderSize {
+			return syserror.EIO
+		}
+		prev = int64(off)
+		off += uint64(curLen)
+	}
+	if prev >= 0 {
+		prevLen := binary.LittleEndian.Uint16(buf[prev+4:])
+		curLen := binary.LittleEndian.Uint16(buf[target+4:])
+		binary.LittleEndian.PutUint16(buf[prev+4:], prevLen+curLen)
+	} else {
+		binary.LittleEndian.PutUint32(buf[target:], 0)
+	}
+	if err := dir.writeBlockLocked(ctx, buf, blkOff); err != nil {
+		return err
+	}
+
+	dir.childList.Remove(d)
+	delete(dir.childMap, name)
+	return nil
+}
+
+// setEntry makes the dirent with the given name point to another inode.
+//
+// Preconditions: filesystem.mu must be locked for writing.
+func (dir *directory) setEntry(ctx context.Context, name string, inodeNum uint32, fileType uint8) error {
+	dir.mu.Lock()
+	defer dir.mu.Unlock()
+	in := &dir.inode
+	in.mu.Lock()
+	defer in.mu.Unlock()
+	if err := dir.checkWritable(); err != nil {
+		return err
+	}
+	d, ok := dir.childMap[name]
+	if !ok {
+		return syserror.ENOENT
+	}
+
+	blkOff := d.off - d.off%in.blkSize
+	buf, err := dir.readBlockLocked(blkOff)
+	if err != nil {
+		return err
+	}
+	binary.LittleEndian.PutUint32(buf[d.off-blkOff:], inodeNum)
+	buf[d.off-blkOff+7] = fileType
+	if err := dir.writeBlockLocked(ctx, buf, blkOff); err != nil {
+		return err
+	}
+
+	diskDirent := d.diskDirent.(*disklayout.DirentNew)
+	diskDirent.InodeNumber = inodeNum
+	diskDirent.FileTypeRaw = fileType
+	return nil
+}
+
+// isEmpty returns true if the directory only contains "." and "..".
+//
+// Preconditions: filesystem.mu must be locked.
+func (dir *directory) isEmpty() bool {
+	for name := range dir.childMap {
+		if name != "." && name != ".." {
+			return false
+		}
+	}
+	return true
+}
+
 // dirent is the directory.childList node.
 //
 // +stateify savable
 type dirent struct {
 	diskDirent disklayout.Dirent
 
+	// off is the offset of the dirent in the directory data.
+	off uint64
+
 	// direntEntry links dirents into their parent directory.childList.
 	direntEntry
 }
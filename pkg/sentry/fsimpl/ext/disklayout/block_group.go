@@ -95,6 +95,34 @@ type BlockGroup interface {
 
 	// Flags returns BGFlags which represents the block group flags.
 	Flags() BGFlags
+
+	// The methods below modify the group descriptor in memory. They are the
+	// counterparts of the accessors above.
+
+	// SetFreeBlocksCount sets the number of free blocks in the group.
+	SetFreeBlocksCount(n uint32)
+
+	// SetFreeInodesCount sets the number of free inodes in the group.
+	SetFreeInodesCount(n uint32)
+
+	// SetDirectoryCount sets the number of directories in the group.
+	SetDirectoryCount(n uint32)
+
+	// SetUnusedInodeCount sets the number of unused inodes beyond the last
+	// used inode in the group's inode table.
+	SetUnusedInodeCount(n uint32)
+
+	// SetBlockBitmapChecksum sets the block bitmap checksum.
+	SetBlockBitmapChecksum(csum uint32)
+
+	// SetInodeBitmapChecksum sets the inode bitmap checksum.
+	SetInodeBitmapChecksum(csum uint32)
+
+	// SetChecksum sets the group descriptor checksum.
+	SetChecksum(csum uint16)
+
+	// SetFlags sets the block group flags.
+	SetFlags(f BGFlags)
 }
 
 // These are the different block group flags.
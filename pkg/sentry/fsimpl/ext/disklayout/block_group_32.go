@@ -72,3 +72,31 @@ func (bg *BlockGroup32Bit) Checksum() uint16 { return bg.ChecksumRaw }
 
 // Flags implements BlockGroup.Flags.
 func (bg *BlockGroup32Bit) Flags() BGFlags { return BGFlagsFromInt(bg.FlagsRaw) }
+
+// SetFreeBlocksCount implements BlockGroup.SetFreeBlocksCount.
+func (bg *BlockGroup32Bit) SetFreeBlocksCount(n uint32) { bg.FreeBlocksCountLo = uint16(n) }
+
+// SetFreeInodesCount implements BlockGroup.SetFreeInodesCount.
+func (bg *BlockGroup32Bit) SetFreeInodesCount(n uint32) { bg.FreeInodesCountLo = uint16(n) }
+
+// SetDirectoryCount implements BlockGroup.SetDirectoryCount.
+func (bg *BlockGroup32Bit) SetDirectoryCount(n uint32) { bg.UsedDirsCountLo = uint16(n) }
+
+// SetUnusedInodeCount implements BlockGroup.SetUnusedInodeCount.
+func (bg *BlockGroup32Bit) SetUnusedInodeCount(n uint32) { bg.ItableUnusedLo = uint16(n) }
+
+// SetBlockBitmapChecksum implements BlockGroup.SetBlockBitmapChecksum.
+func (bg *BlockGroup32Bit) SetBlockBitmapChecksum(csum uint32) {
+	bg.BlockBitmapChecksumLo = uint16(csum)
+}
+
+// SetInodeBitmapChecksum implements BlockGroup.SetInodeBitmapChecksum.
+func (bg *BlockGroup32Bit) SetInodeBitmapChecksum(csum uint32) {
+	bg.InodeBitmapChecksumLo = uint16(csum)
+}
+
+// SetChecksum implements BlockGroup.SetChecksum.
+func (bg *BlockGroup32Bit) SetChecksum(csum uint16) { bg.ChecksumRaw = csum }
+
+// SetFlags implements BlockGroup.SetFlags.
+func (bg *BlockGroup32Bit) SetFlags(f BGFlags) { bg.FlagsRaw = f.ToInt() }
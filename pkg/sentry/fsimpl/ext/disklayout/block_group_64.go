@@ -42,7 +42,8 @@ type BlockGroup64Bit struct {
 // Compiles only if BlockGroup64Bit implements BlockGroup.
 var _ BlockGroup = (*BlockGroup64Bit)(nil)
 
-// Methods to override. Checksum() and Flags() are not overridden.
+// Methods to override. Checksum(), Flags() and their setters are not
+// overridden.
 
 // InodeTable implements BlockGroup.InodeTable.
 func (bg *BlockGroup64Bit) InodeTable() uint64 {
@@ -93,3 +94,39 @@ func (bg *BlockGroup64Bit) BlockBitmapChecksum() uint32 {
 func (bg *BlockGroup64Bit) InodeBitmapChecksum() uint32 {
 	return (uint32(bg.InodeBitmapChecksumHi) << 16) | uint32(bg.InodeBitmapChecksumLo)
 }
+
+// SetFreeBlocksCount implements BlockGroup.SetFreeBlocksCount.
+func (bg *BlockGroup64Bit) SetFreeBlocksCount(n uint32) {
+	bg.FreeBlocksCountLo = uint16(n)
+	bg.FreeBlocksCountHi = uint16(n >> 16)
+}
+
+// SetFreeInodesCount implements BlockGroup.SetFreeInodesCount.
+func (bg *BlockGroup64Bit) SetFreeInodesCount(n uint32) {
+	bg.FreeInodesCountLo = uint16(n)
+	bg.FreeInodesCountHi = uint16(n >> 16)
+}
+
+// SetDirectoryCount implements BlockGroup.SetDirectoryCount.
+func (bg *BlockGroup64Bit) SetDirectoryCount(n uint32) {
+	bg.UsedDirsCountLo = uint16(n)
+	bg.UsedDirsCountHi = uint16(n >> 16)
+}
+
+// SetUnusedInodeCount implements BlockGroup.SetUnusedInodeCount.
+func (bg *BlockGroup64Bit) SetUnusedInodeCount(n uint32) {
+	bg.ItableUnusedLo = uint16(n)
+	bg.ItableUnusedHi = uint16(n >> 16)
+}
+
+// SetBlockBitmapChecksum implements BlockGroup.SetBlockBitmapChecksum.
+func (bg *BlockGroup64Bit) SetBlockBitmapChecksum(csum uint32) {
+	bg.BlockBitmapChecksumLo = uint16(csum)
+	bg.BlockBitmapChecksumHi = uint16(csum >> 16)
+}
+
+// SetInodeBitmapChecksum implements BlockGroup.SetInodeBitmapChecksum.
+func (bg *BlockGroup64Bit) SetInodeBitmapChecksum(csum uint32) {
+	bg.InodeBitmapChecksumLo = uint16(csum)
+	bg.InodeBitmapChecksumHi = uint16(csum >> 16)
+}
@@ -15,6 +15,7 @@
 package disklayout
 
 import (
+	"gvisor.dev/gvisor/pkg/abi/linux"
 	"gvisor.dev/gvisor/pkg/marshal"
 	"gvisor.dev/gvisor/pkg/sentry/fs"
 )
@@ -25,6 +26,19 @@ const (
 
 	// DirentSize is the size of ext dirent structures.
 	DirentSize = 263
+
+	// DirentHeaderSize is the size of the fields of ext dirent structures
+	// which precede the file name.
+	DirentHeaderSize = 8
+
+	// DirentTailSize is the size of the fake dirent at the end of directory
+	// blocks which holds the checksum of the block, on filesystems with
+	// metadata checksums. Its inode number and name length are 0 and its
+	// file type is DirentTailFileType.
+	DirentTailSize = 12
+
+	// DirentTailFileType is the file type of dirent tails.
+	DirentTailFileType = 0xDE
 )
 
 var (
@@ -41,8 +55,31 @@ var (
 		6: fs.Socket,
 		7: fs.Symlink,
 	}
+
+	// fileTypeByMode maps file types in inode modes to ext4 file types.
+	fileTypeByMode = map[uint16]uint8{
+		linux.ModeRegular:         1,
+		linux.ModeDirectory:       2,
+		linux.ModeCharacterDevice: 3,
+		linux.ModeBlockDevice:     4,
+		linux.ModeNamedPipe:       5,
+		linux.ModeSocket:          6,
+		linux.ModeSymlink:         7,
+	}
 )
 
+// FileTypeFromMode returns the file type recorded in dirents pointing to an
+// inode with the given mode, when the SbDirentFileType feature is set.
+func FileTypeFromMode(mode linux.FileMode) uint8 {
+	return fileTypeByMode[uint16(mode.FileType())]
+}
+
+// DirentRecordSize returns the minimum record length of a dirent with a file
+// name of the given length.
+func DirentRecordSize(nameLen int) uint16 {
+	return uint16((DirentHeaderSize + nameLen + 3) &^ 3)
+}
+
 // The Dirent interface should be implemented by structs representing ext
 // directory entries. These are for the linear classical directories which
 // just store a list of dirent structs. A directory is a series of data blocks
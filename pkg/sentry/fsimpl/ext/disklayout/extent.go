@@ -42,6 +42,21 @@ const (
 
 	// ExtentMagic is the magic number which must be present in the header.
 	ExtentMagic = 0xf30a
+
+	// ExtentTailSize is the size of the checksum which follows the entries of
+	// extent tree nodes stored in blocks, on filesystems with metadata
+	// checksums. It is located at ExtentHeaderSize+MaxEntries*ExtentEntrySize.
+	ExtentTailSize = 4
+
+	// ExtentRootMaxEntries is the maximum number of entries of the root node,
+	// which lives in the 60 byte Inode.Data().
+	ExtentRootMaxEntries = 4
+
+	// MaxInitExtentLength is the maximum number of blocks an initialized
+	// extent can cover. Extents with a greater Length are uninitialized: they
+	// cover (Length - MaxInitExtentLength) preallocated blocks which read as
+	// zeros.
+	MaxInitExtentLength = 32768
 )
 
 // ExtentEntryPair couples an in-memory ExtendNode with the ExtentEntry that
@@ -127,6 +142,12 @@ func (ei *ExtentIdx) PhysicalBlock() uint64 {
 	return (uint64(ei.ChildBlockHi) << 32) | uint64(ei.ChildBlockLo)
 }
 
+// SetPhysicalBlock sets the physical block number of the child block.
+func (ei *ExtentIdx) SetPhysicalBlock(blk uint64) {
+	ei.ChildBlockLo = uint32(blk)
+	ei.ChildBlockHi = uint16(blk >> 32)
+}
+
 // Extent represents the ext4_extent struct in ext4. Only present in leaf
 // nodes. Sorted in ascending order based on FirstFileBlock since Linux does a
 // binary search on this. This points to an array of data blocks containing the
@@ -153,3 +174,24 @@ func (e *Extent) FileBlock() uint32 {
 func (e *Extent) PhysicalBlock() uint64 {
 	return (uint64(e.StartBlockHi) << 32) | uint64(e.StartBlockLo)
 }
+
+// SetPhysicalBlock sets the physical block number of the first data block
+// this extent covers.
+func (e *Extent) SetPhysicalBlock(blk uint64) {
+	e.StartBlockLo = uint32(blk)
+	e.StartBlockHi = uint16(blk >> 32)
+}
+
+// Len returns the number of data blocks this extent covers.
+func (e *Extent) Len() uint16 {
+	if e.Length > MaxInitExtentLength {
+		return e.Length - MaxInitExtentLength
+	}
+	return e.Length
+}
+
+// Uninitialized returns true if this extent covers preallocated data blocks
+// which have not been written to.
+func (e *Extent) Uninitialized() bool {
+	return e.Length > MaxInitExtentLength
+}
@@ -127,6 +127,60 @@ type Inode interface {
 	//
 	// See https://www.kernel.org/doc/html/latest/filesystems/ext4/dynamic.html#the-contents-of-inode-i-block.
 	Data() []byte
+
+	// BlocksCount returns the number of 512-byte sectors used by the file,
+	// including metadata blocks such as extent tree nodes. If InHugeFile is
+	// set, it is the number of filesystem blocks instead.
+	BlocksCount() uint64
+
+	// Generation returns the file version, which seeds metadata checksums of
+	// the inode.
+	Generation() uint32
+
+	// The methods below modify the inode in memory. They are the counterparts
+	// of the accessors above, and the inode must be written back to disk for
+	// the changes to persist.
+
+	// SetMode sets the file mode.
+	SetMode(mode linux.FileMode)
+
+	// SetUID sets the owner UID.
+	SetUID(uid auth.KUID)
+
+	// SetGID sets the owner GID.
+	SetGID(gid auth.KGID)
+
+	// SetSize sets the size of the file in bytes.
+	SetSize(size uint64)
+
+	// SetAccessTime sets the last access time.
+	SetAccessTime(t time.Time)
+
+	// SetChangeTime sets the last change time.
+	SetChangeTime(t time.Time)
+
+	// SetModificationTime sets the last modification time.
+	SetModificationTime(t time.Time)
+
+	// SetDeletionTime sets the deletion time.
+	SetDeletionTime(t time.Time)
+
+	// SetLinksCount sets the number of hard links to this inode.
+	SetLinksCount(n uint16)
+
+	// SetFlags sets the inode flags.
+	SetFlags(f InodeFlags)
+
+	// SetBlocksCount sets the number of blocks used by the file, in the units
+	// described by BlocksCount.
+	SetBlocksCount(n uint64)
+
+	// SetGeneration sets the file version.
+	SetGeneration(gen uint32)
+
+	// SetChecksum sets the metadata checksum of the inode. Inodes without room
+	// for the upper 16 bits of the checksum only store the lower 16 bits.
+	SetChecksum(csum uint32)
 }
 
 // Inode flags. This is not comprehensive and flags which were not used in
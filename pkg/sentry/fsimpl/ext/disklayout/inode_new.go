@@ -55,6 +55,14 @@ func fromExtraTime(lo int32, extra uint32) time.Time {
 	return time.FromUnix(seconds, nanoseconds)
 }
 
+// toExtraTime encodes t in the format decoded by fromExtraTime.
+func toExtraTime(t time.Time) (int32, uint32) {
+	seconds, nanoseconds := t.Unix()
+	lo := int32(seconds)
+	extra := uint32((seconds-int64(lo))>>32)&0x3 | uint32(nanoseconds)<<2
+	return lo, extra
+}
+
 // Only override methods which change due to ext4 specific fields.
 
 // Size implements Inode.Size.
@@ -96,3 +104,45 @@ func (in *InodeNew) AccessTime() time.Time {
 
 	return in.InodeOld.AccessTime()
 }
+
+// SetSize implements Inode.SetSize.
+func (in *InodeNew) SetSize(size uint64) {
+	in.SizeLo = uint32(size)
+	in.SizeHi = uint32(size >> 32)
+}
+
+// SetChangeTime implements Inode.SetChangeTime.
+func (in *InodeNew) SetChangeTime(t time.Time) {
+	if in.ExtraInodeSize >= 8 {
+		in.ChangeTimeRaw, in.ChangeTimeExtra = toExtraTime(t)
+		return
+	}
+	in.InodeOld.SetChangeTime(t)
+}
+
+// SetModificationTime implements Inode.SetModificationTime.
+func (in *InodeNew) SetModificationTime(t time.Time) {
+	if in.ExtraInodeSize >= 12 {
+		in.ModificationTimeRaw, in.ModificationTimeExtra = toExtraTime(t)
+		return
+	}
+	in.InodeOld.SetModificationTime(t)
+}
+
+// SetAccessTime implements Inode.SetAccessTime.
+func (in *InodeNew) SetAccessTime(t time.Time) {
+	if in.ExtraInodeSize >= 16 {
+		in.AccessTimeRaw, in.AccessTimeExtra = toExtraTime(t)
+		return
+	}
+	in.InodeOld.SetAccessTime(t)
+}
+
+// SetChecksum implements Inode.SetChecksum.
+func (in *InodeNew) SetChecksum(csum uint32) {
+	in.ChecksumLo = uint16(csum)
+	// inode.ChecksumHi is in scope if the extra inode size covers it.
+	if in.ExtraInodeSize >= 4 {
+		in.ChecksumHi = uint16(csum >> 16)
+	}
+}
@@ -50,7 +50,7 @@ type InodeOld struct {
 	FlagsRaw      uint32
 	VersionLo     uint32 // This is OS dependent.
 	DataRaw       [60]byte
-	Generation    uint32
+	GenerationRaw uint32
 	FileACLLo     uint32
 	SizeHi        uint32
 	ObsoFaddr     uint32
@@ -82,7 +82,12 @@ func (in *InodeOld) GID() auth.KGID {
 
 // Size implements Inode.Size.
 func (in *InodeOld) Size() uint64 {
-	// In ext2/ext3, in.SizeHi did not exist, it was instead named in.DirACL.
+	// In ext2/ext3, in.SizeHi did not exist for directories, it was instead
+	// named in.DirACL. It has always held the upper bits of the size of
+	// regular files on filesystems with the large_file feature.
+	if in.Mode().FileType() == linux.ModeRegular {
+		return (uint64(in.SizeHi) << 32) | uint64(in.SizeLo)
+	}
 	return uint64(in.SizeLo)
 }
 
@@ -117,3 +122,66 @@ func (in *InodeOld) Flags() InodeFlags { return InodeFlagsFromInt(in.FlagsRaw) }
 
 // Data implements Inode.Data.
 func (in *InodeOld) Data() []byte { return in.DataRaw[:] }
+
+// BlocksCount implements Inode.BlocksCount.
+func (in *InodeOld) BlocksCount() uint64 {
+	return (uint64(in.BlocksCountHi) << 32) | uint64(in.BlocksCountLo)
+}
+
+// Generation implements Inode.Generation.
+func (in *InodeOld) Generation() uint32 { return in.GenerationRaw }
+
+// SetMode implements Inode.SetMode.
+func (in *InodeOld) SetMode(mode linux.FileMode) { in.ModeRaw = uint16(mode) }
+
+// SetUID implements Inode.SetUID.
+func (in *InodeOld) SetUID(uid auth.KUID) {
+	in.UIDLo = uint16(uid)
+	in.UIDHi = uint16(uid >> 16)
+}
+
+// SetGID implements Inode.SetGID.
+func (in *InodeOld) SetGID(gid auth.KGID) {
+	in.GIDLo = uint16(gid)
+	in.GIDHi = uint16(gid >> 16)
+}
+
+// SetSize implements Inode.SetSize.
+func (in *InodeOld) SetSize(size uint64) {
+	in.SizeLo = uint32(size)
+	if in.Mode().FileType() == linux.ModeRegular {
+		in.SizeHi = uint32(size >> 32)
+	}
+}
+
+// SetAccessTime implements Inode.SetAccessTime.
+func (in *InodeOld) SetAccessTime(t time.Time) { in.AccessTimeRaw = int32(t.Seconds()) }
+
+// SetChangeTime implements Inode.SetChangeTime.
+func (in *InodeOld) SetChangeTime(t time.Time) { in.ChangeTimeRaw = int32(t.Seconds()) }
+
+// SetModificationTime implements Inode.SetModificationTime.
+func (in *InodeOld) SetModificationTime(t time.Time) {
+	in.ModificationTimeRaw = int32(t.Seconds())
+}
+
+// SetDeletionTime implements Inode.SetDeletionTime.
+func (in *InodeOld) SetDeletionTime(t time.Time) { in.DeletionTimeRaw = int32(t.Seconds()) }
+
+// SetLinksCount implements Inode.SetLinksCount.
+func (in *InodeOld) SetLinksCount(n uint16) { in.LinksCountRaw = n }
+
+// SetFlags implements Inode.SetFlags.
+func (in *InodeOld) SetFlags(f InodeFlags) { in.FlagsRaw = f.ToInt() }
+
+// SetBlocksCount implements Inode.SetBlocksCount.
+func (in *InodeOld) SetBlocksCount(n uint64) {
+	in.BlocksCountLo = uint32(n)
+	in.BlocksCountHi = uint16(n >> 32)
+}
+
+// SetGeneration implements Inode.SetGeneration.
+func (in *InodeOld) SetGeneration(gen uint32) { in.GenerationRaw = gen }
+
+// SetChecksum implements Inode.SetChecksum.
+func (in *InodeOld) SetChecksum(csum uint32) { in.ChecksumLo = uint16(csum) }
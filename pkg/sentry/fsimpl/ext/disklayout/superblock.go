@@ -148,6 +148,16 @@ const (
 	DynamicRev SbRevision = 1
 )
 
+// Superblock states.
+const (
+	// SbValidFS indicates that the filesystem was cleanly unmounted. It is
+	// cleared while the filesystem is mounted read/write.
+	SbValidFS = 0x1
+
+	// SbErrorFS indicates that errors were detected in the filesystem.
+	SbErrorFS = 0x2
+)
+
 // Superblock compatible features.
 // This is not exhaustive, unused features are not listed.
 const (
@@ -267,6 +277,11 @@ const (
 	// See https://www.kernel.org/doc/html/latest/filesystems/ext4/overview.html#flexible-block-groups.
 	SbFlexBg = 0x200
 
+	// SbCsumSeed indicates that the seed of metadata checksums is stored in the
+	// superblock instead of being derived from the filesystem UUID. This
+	// allows changing the UUID without rewriting all metadata checksums.
+	SbCsumSeed = 0x2000
+
 	// SbLargeDir shows that large directory enabled. Directory htree can be 3
 	// levels deep. Directory htrees are allowed to be 2 levels deep otherwise.
 	SbLargeDir = 0x4000
@@ -289,6 +304,7 @@ type IncompatFeatures struct {
 	Is64Bit        bool
 	MMP            bool
 	FlexBg         bool
+	CsumSeed       bool
 	LargeDir       bool
 	InlineData     bool
 	Encrypted      bool
@@ -322,6 +338,9 @@ func (f IncompatFeatures) ToInt() uint32 {
 	if f.FlexBg {
 		res |= SbFlexBg
 	}
+	if f.CsumSeed {
+		res |= SbCsumSeed
+	}
 	if f.LargeDir {
 		res |= SbLargeDir
 	}
@@ -347,6 +366,7 @@ func IncompatFeaturesFromInt(f uint32) IncompatFeatures {
 		Is64Bit:        f&SbIs64Bit > 0,
 		MMP:            f&SbMMP > 0,
 		FlexBg:         f&SbFlexBg > 0,
+		CsumSeed:       f&SbCsumSeed > 0,
 		LargeDir:       f&SbLargeDir > 0,
 		InlineData:     f&SbInlineData > 0,
 		Encrypted:      f&SbEncrypted > 0,
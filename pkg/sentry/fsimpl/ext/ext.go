@@ -12,7 +12,15 @@
 // See the License for the specific language governing permissions and
 // limitations under the License.
 
-// Package ext implements readonly ext(2/3/4) filesystems.
+// Package ext implements ext(2/3/4) filesystems. Filesystems are read-only,
+// except for ext4 filesystems which only use supported features (see
+// checkWritable) on writable devices.
+//
+// Writes are not journaled. Instead, metadata is written in an order that
+// keeps the filesystem consistent except for leaked blocks and inodes: data
+// blocks and inodes are written before the metadata referring to them, and
+// references are removed before what they refer to is freed. Each write is
+// issued to the device directly, without caching.
 package ext
 
 import (
@@ -20,6 +28,7 @@ import (
 	"fmt"
 	"io"
 
+	"golang.org/x/sys/unix"
 	"gvisor.dev/gvisor/pkg/abi/linux"
 	"gvisor.dev/gvisor/pkg/context"
 	"gvisor.dev/gvisor/pkg/fd"
@@ -38,41 +47,60 @@ const Name = "ext"
 // +stateify savable
 type FilesystemType struct{}
 
-// getDeviceFd returns an io.ReaderAt to the underlying device.
+// getDeviceFd returns an io.ReaderAt to the underlying device, and an
+// io.WriterAt to it if it is writable. If the device is a block device in the
+// sentry, it is also returned as a vfs.Disk, on which the caller must drop the
+// reference.
 // Currently there are two ways of mounting an ext(2/3/4) fs:
 //   1. Specify a mount with our internal special MountType in the OCI spec.
 //   2. Expose the device to the container and mount it from application layer.
-func getDeviceFd(source string, opts vfs.GetFilesystemOptions) (io.ReaderAt, error) {
+func getDeviceFd(ctx context.Context, vfsObj *vfs.VirtualFilesystem, creds *auth.Credentials, source string, opts vfs.GetFilesystemOptions) (io.ReaderAt, io.WriterAt, vfs.Disk, error) {
 	if opts.InternalData == nil {
 		// User mount call.
-		// TODO(b/134676337): Open the device specified by `source` and return that.
-		panic("unimplemented")
+		disk, err := vfsObj.GetDiskFromSource(ctx, creds, source)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		// The filesystem reads the disk outside of the tasks' syscalls, so
+		// its I/O isn't done on behalf of any task.
+		rw := vfs.DiskIO{Ctx: context.Background(), Disk: disk}
+		if disk.ReadOnly() {
+			return rw, nil, disk, nil
+		}
+		return rw, rw, disk, nil
 	}
 
 	// GetFilesystem call originated from within the sentry.
 	devFd, ok := opts.InternalData.(int)
 	if !ok {
-		return nil, errors.New("internal data for ext fs must be an int containing the file descriptor to device")
+		return nil, nil, nil, errors.New("internal data for ext fs must be an int containing the file descriptor to device")
 	}
 
 	if devFd < 0 {
-		return nil, fmt.Errorf("ext device file descriptor is not valid: %d", devFd)
+		return nil, nil, nil, fmt.Errorf("ext device file descriptor is not valid: %d", devFd)
 	}
 
 	// The fd.ReadWriter returned from fd.NewReadWriter() does not take ownership
 	// of the file descriptor and hence will not close it when it is garbage
 	// collected.
-	return fd.NewReadWriter(devFd), nil
+	rw := fd.NewReadWriter(devFd)
+	flags, err := unix.FcntlInt(uintptr(devFd), unix.F_GETFL, 0)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	if flags&unix.O_ACCMODE == unix.O_RDONLY {
+		return rw, nil, nil, nil
+	}
+	return rw, rw, nil, nil
 }
 
 // isCompatible checks if the superblock has feature sets which are compatible.
 // We only need to check the superblock incompatible feature set since we are
-// mounting readonly. We will also need to check readonly compatible feature
-// set when mounting for read/write.
+// mounting readonly. Filesystems are only mounted read/write if they pass the
+// stricter checkWritable.
 func isCompatible(sb disklayout.SuperBlock) bool {
 	// Please note that what is being checked is limited based on the fact that we
-	// are mounting readonly and that we are not journaling. When mounting
-	// read/write or with a journal, this must be reevaluated.
+	// are mounting readonly and that we are not journaling.
 	incompatFeatures := sb.IncompatibleFeatures()
 	if incompatFeatures.MetaBG {
 		log.Warningf("ext fs: meta block groups are not supported")
@@ -103,22 +131,20 @@ func (FilesystemType) Release(ctx context.Context) {}
 
 // GetFilesystem implements vfs.FilesystemType.GetFilesystem.
 func (fsType FilesystemType) GetFilesystem(ctx context.Context, vfsObj *vfs.VirtualFilesystem, creds *auth.Credentials, source string, opts vfs.GetFilesystemOptions) (*vfs.Filesystem, *vfs.Dentry, error) {
-	// TODO(b/134676337): Ensure that the user is mounting readonly. If not,
-	// EACCESS should be returned according to mount(2). Filesystem independent
-	// flags (like readonly) are currently not available in pkg/sentry/vfs.
-
 	devMinor, err := vfsObj.GetAnonBlockDevMinor()
 	if err != nil {
 		return nil, nil, err
 	}
 
-	dev, err := getDeviceFd(source, opts)
+	dev, devWriter, disk, err := getDeviceFd(ctx, vfsObj, creds, source, opts)
 	if err != nil {
+		vfsObj.PutAnonBlockDevMinor(devMinor)
 		return nil, nil, err
 	}
 
 	fs := filesystem{
 		dev:        dev,
+		disk:       disk,
 		inodeCache: make(map[uint32]*inode),
 		devMinor:   devMinor,
 	}
@@ -128,6 +154,11 @@ func (fsType FilesystemType) GetFilesystem(ctx context.Context, vfsObj *vfs.Virt
 		fs.vfsfs.DecRef(ctx)
 		return nil, nil, err
 	}
+	fs.sbRaw, err = readRawSuperBlock(dev)
+	if err != nil {
+		fs.vfsfs.DecRef(ctx)
+		return nil, nil, err
+	}
 
 	if fs.sb.Magic() != linux.EXT_SUPER_MAGIC {
 		// mount(2) specifies that EINVAL should be returned if the superblock is
@@ -147,6 +178,28 @@ func (fsType FilesystemType) GetFilesystem(ctx context.Context, vfsObj *vfs.Virt
 		fs.vfsfs.DecRef(ctx)
 		return nil, nil, err
 	}
+	for _, bg := range fs.bgs {
+		fs.freeBlocksCount += uint64(bg.FreeBlocksCount())
+		fs.freeInodesCount += bg.FreeInodesCount()
+	}
+	roCompat := fs.sb.ReadOnlyCompatibleFeatures()
+	fs.metadataCsum = roCompat.MetadataCsum
+	fs.uninitBG = roCompat.MetadataCsum || roCompat.GdtCsum
+	fs.csumSeed = checksumSeed(fs.sbRaw)
+
+	// Mount read-only if the device or the filesystem can't be written, or
+	// if asked to, since mount flags are not passed to filesystems.
+	fs.readOnly = true
+	if _, ok := vfs.GenericParseMountOptions(opts.Data)["ro"]; ok {
+		log.Infof("ext fs: mounting read-only as requested")
+	} else if devWriter == nil {
+		log.Infof("ext fs: mounting read-only since the device is read-only")
+	} else if err := checkWritable(fs.sb, fs.sbRaw); err != nil {
+		log.Warningf("ext fs: mounting read-only: %v", err)
+	} else {
+		fs.readOnly = false
+		fs.devWriter = devWriter
+	}
 
 	rootInode, err := fs.getOrCreateInodeLocked(disklayout.RootDirInode)
 	if err != nil {
@@ -17,6 +17,7 @@ package ext
 import (
 	"fmt"
 	"io"
+	"io/ioutil"
 	"os"
 	"path"
 	"sort"
@@ -59,7 +60,38 @@ func setUp(t *testing.T, imagePath string) (context.Context, *vfs.VirtualFilesys
 	if err != nil {
 		return nil, nil, nil, nil, err
 	}
+	return mountImage(t, localImagePath, f)
+}
+
+// setUpWritable is like setUp, but mounts a writable copy of imagePath. The
+// copy is returned so that it can be mounted again with mountImage.
+func setUpWritable(t *testing.T, imagePath string) (context.Context, *vfs.VirtualFilesystem, *vfs.VirtualDentry, func(), *os.File, error) {
+	localImagePath, err := testutil.FindFile(imagePath)
+	if err != nil {
+		return nil, nil, nil, nil, nil, fmt.Errorf("failed to open local image at path %s: %v", imagePath, err)
+	}
+	image, err := ioutil.ReadFile(localImagePath)
+	if err != nil {
+		return nil, nil, nil, nil, nil, err
+	}
+	f, err := ioutil.TempFile("", "ext_test")
+	if err != nil {
+		return nil, nil, nil, nil, nil, err
+	}
+	os.Remove(f.Name())
+	if _, err := f.Write(image); err != nil {
+		f.Close()
+		return nil, nil, nil, nil, nil, err
+	}
+	ctx, vfsObj, root, tearDown, err := mountImage(t, f.Name(), f)
+	if err != nil {
+		return nil, nil, nil, nil, nil, err
+	}
+	return ctx, vfsObj, root, tearDown, f, nil
+}
 
+// mountImage mounts the image in f as an ext Filesystem. tearDown closes f.
+func mountImage(t *testing.T, source string, f *os.File) (context.Context, *vfs.VirtualFilesystem, *vfs.VirtualDentry, func(), error) {
 	ctx := contexttest.Context(t)
 	creds := auth.CredentialsFromContext(ctx)
 
@@ -71,7 +103,7 @@ func setUp(t *testing.T, imagePath string) (context.Context, *vfs.VirtualFilesys
 	vfsObj.MustRegisterFilesystemType("extfs", FilesystemType{}, &vfs.RegisterFilesystemTypeOptions{
 		AllowUserMount: true,
 	})
-	mntns, err := vfsObj.NewMountNamespace(ctx, creds, localImagePath, "extfs", &vfs.MountOptions{
+	mntns, err := vfsObj.NewMountNamespace(ctx, creds, source, "extfs", &vfs.MountOptions{
 		GetFilesystemOptions: vfs.GetFilesystemOptions{
 			InternalData: int(f.Fd()),
 		},
@@ -924,3 +956,80 @@ func TestFilesystemInit(t *testing.T) {
 		})
 	}
 }
+
+// TestWrite tests that files and directories can be created, written, renamed
+// and removed on writable ext4 filesystems, and that the changes persist.
+func TestWrite(t *testing.T) {
+	ctx, vfsObj, root, tearDown, image, err := setUpWritable(t, ext4ImagePath)
+	if err != nil {
+		t.Fatalf("setUpWritable failed: %v", err)
+	}
+	creds := auth.CredentialsFromContext(ctx)
+	pop := func(p string) *vfs.PathOperation {
+		return &vfs.PathOperation{Root: *root, Start: *root, Path: fspath.Parse(p)}
+	}
+
+	if err := vfsObj.MkdirAt(ctx, creds, pop("/dir"), &vfs.MkdirOptions{Mode: 0755}); err != nil {
+		t.Fatalf("MkdirAt failed: %v", err)
+	}
+	fd, err := vfsObj.OpenAt(ctx, creds, pop("/dir/file"), &vfs.OpenOptions{
+		Flags: linux.O_CREAT | linux.O_EXCL | linux.O_RDWR,
+		Mode:  0644,
+	})
+	if err != nil {
+		t.Fatalf("OpenAt failed: %v", err)
+	}
+	// Write more than a block, with a hole.
+	want := make([]byte, 3000)
+	for i := range want[1500:] {
+		want[1500+i] = byte(i)
+	}
+	if n, err := fd.PWrite(ctx, usermem.BytesIOSequence(want[1500:]), 1500, vfs.WriteOptions{}); err != nil || n != 1500 {
+		t.Fatalf("PWrite failed: n = %d, err = %v", n, err)
+	}
+	fd.DecRef(ctx)
+	if err := vfsObj.RenameAt(ctx, creds, pop("/dir/file"), pop("/renamed"), &vfs.RenameOptions{}); err != nil {
+		t.Fatalf("RenameAt failed: %v", err)
+	}
+	if err := vfsObj.RmdirAt(ctx, creds, pop("/dir")); err != nil {
+		t.Fatalf("RmdirAt failed: %v", err)
+	}
+	if err := vfsObj.SymlinkAt(ctx, creds, pop("/link"), "renamed"); err != nil {
+		t.Fatalf("SymlinkAt failed: %v", err)
+	}
+	if err := vfsObj.UnlinkAt(ctx, creds, pop("/file.txt")); err != nil {
+		t.Fatalf("UnlinkAt failed: %v", err)
+	}
+
+	// Check the changes after mounting the image again. All changes are
+	// written to the device immediately.
+	defer tearDown()
+	f, err := os.Open(fmt.Sprintf("/proc/self/fd/%d", image.Fd()))
+	if err != nil {
+		t.Fatalf("failed to reopen the image: %v", err)
+	}
+	ctx, vfsObj, root, tearDown, err = mountImage(t, image.Name(), f)
+	if err != nil {
+		t.Fatalf("mountImage failed: %v", err)
+	}
+	defer tearDown()
+
+	fd, err = vfsObj.OpenAt(ctx, creds, pop("/link"), &vfs.OpenOptions{})
+	if err != nil {
+		t.Fatalf("OpenAt failed: %v", err)
+	}
+	defer fd.DecRef(ctx)
+	got := make([]byte, len(want)+1)
+	n, err := fd.PRead(ctx, usermem.BytesIOSequence(got), 0, vfs.ReadOptions{})
+	if err != nil && err != io.EOF {
+		t.Fatalf("PRead failed: %v", err)
+	}
+	if diff := cmp.Diff(want, got[:n]); diff != "" {
+		t.Errorf("file data mismatch (-want +got):\n%s", diff)
+	}
+	for _, p := range []string{"/dir", "/file.txt"} {
+		if _, err := vfsObj.StatAt(ctx, creds, pop(p), &vfs.StatOptions{}); err != syserror.ENOENT {
+			t.Errorf("StatAt(%q) got error %v, want %v", p, err, syserror.ENOENT)
+		}
+	}
+}
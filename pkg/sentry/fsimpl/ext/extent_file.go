@@ -15,6 +15,7 @@
 package ext
 
 import (
+	"encoding/binary"
 	"io"
 	"sort"
 
@@ -29,7 +30,7 @@ type extentFile struct {
 	regFile regularFile
 
 	// root is the root extent node. This lives in the 60 byte diskInode.Data().
-	// Immutable.
+	// The extent tree is protected by inode.mu.
 	root disklayout.ExtentNode
 }
 
@@ -138,7 +139,8 @@ func (f *extentFile) buildExtTreeFromDisk(entry disklayout.ExtentEntry) (*diskla
 	return &disklayout.ExtentNode{header, entries}, nil
 }
 
-// ReadAt implements io.ReaderAt.ReadAt.
+// ReadAt implements io.ReaderAt.ReadAt. Holes and uninitialized extents read
+// as zeros.
 func (f *extentFile) ReadAt(dst []byte, off int64) (int, error) {
 	if len(dst) == 0 {
 		return 0, nil
@@ -148,55 +150,86 @@ func (f *extentFile) ReadAt(dst []byte, off int64) (int, error) {
 		return 0, syserror.EINVAL
 	}
 
-	if uint64(off) >= f.regFile.inode.diskInode.Size() {
+	size := f.regFile.inode.diskInode.Size()
+	if uint64(off) >= size {
 		return 0, io.EOF
 	}
 
-	n, err := f.read(&f.root, uint64(off), dst)
-	if n < len(dst) && err == nil {
-		err = io.EOF
+	toRead := dst
+	if uint64(off)+uint64(len(dst)) > size {
+		toRead = dst[:size-uint64(off)]
 	}
-	return n, err
+	blkSize := f.regFile.inode.blkSize
+	read := 0
+	for read < len(toRead) {
+		cur := uint64(off) + uint64(read)
+		fileBlk := uint32(cur / blkSize)
+		ex, next := f.findExtent(&f.root, fileBlk)
+		if ex == nil || ex.Uninitialized() {
+			end := next * blkSize
+			if ex != nil {
+				end = uint64(ex.FileBlock()+uint32(ex.Len())) * blkSize
+			}
+			n := len(toRead) - read
+			if uint64(n) > end-cur {
+				n = int(end - cur)
+			}
+			for i := range toRead[read : read+n] {
+				toRead[read+i] = 0
+			}
+			read += n
+			continue
+		}
+
+		n, err := f.readFromExtent(ex, cur, toRead[read:])
+		read += n
+		if err != nil {
+			return read, err
+		}
+	}
+
+	if read < len(dst) {
+		return read, io.EOF
+	}
+	return read, nil
 }
 
-// read is the recursive step of extentFile.ReadAt which traverses the extent
-// tree from the node passed and reads file data.
-func (f *extentFile) read(node *disklayout.ExtentNode, off uint64, dst []byte) (int, error) {
-	// Perform a binary search for the node covering bytes starting at r.fileOff.
-	// A highly fragmented filesystem can have upto 340 entries and so linear
-	// search should be avoided. Finds the first entry which does not cover the
-	// file block we want and subtracts 1 to get the desired index.
-	fileBlk := uint32(off / f.regFile.inode.blkSize)
+// noNextExtent is returned by findExtent when no extent follows the searched
+// file block.
+const noNextExtent = uint64(1) << 32
+
+// findExtent returns the extent covering fileBlk in the subtree rooted at node,
+// or nil if fileBlk is in a hole. It also returns a lower bound of the first
+// file block mapped past fileBlk, which is noNextExtent if there is none.
+func (f *extentFile) findExtent(node *disklayout.ExtentNode, fileBlk uint32) (*disklayout.Extent, uint64) {
+	// Perform a binary search for the entry covering fileBlk. A highly
+	// fragmented filesystem can have upto 340 entries and so linear search
+	// should be avoided. Finds the first entry which does not cover the file
+	// block we want and subtracts 1 to get the desired index.
 	n := len(node.Entries)
 	found := sort.Search(n, func(i int) bool {
 		return node.Entries[i].Entry.FileBlock() > fileBlk
 	}) - 1
 
-	// We should be in this recursive step only if the data we want exists under
-	// the current node.
+	next := noNextExtent
+	if found+1 < n {
+		next = uint64(node.Entries[found+1].Entry.FileBlock())
+	}
 	if found < 0 {
-		panic("searching for a file block in an extent entry which does not cover it")
+		return nil, next
 	}
-
-	read := 0
-	toRead := len(dst)
-	var curR int
-	var err error
-	for i := found; i < n && read < toRead; i++ {
-		if node.Header.Height == 0 {
-			curR, err = f.readFromExtent(node.Entries[i].Entry.(*disklayout.Extent), off, dst[read:])
-		} else {
-			curR, err = f.read(node.Entries[i].Node, off, dst[read:])
-		}
-
-		read += curR
-		off += uint64(curR)
-		if err != nil {
-			return read, err
+	if node.Header.Height > 0 {
+		ex, childNext := f.findExtent(node.Entries[found].Node, fileBlk)
+		if childNext < next {
+			next = childNext
 		}
+		return ex, next
 	}
-
-	return read, nil
+	ex := node.Entries[found].Entry.(*disklayout.Extent)
+	if fileBlk >= ex.FileBlock()+uint32(ex.Len()) {
+		return nil, next
+	}
+	return ex, next
 }
 
 // readFromExtent reads file data from the extent. It takes advantage of the
@@ -204,18 +237,14 @@ func (f *extentFile) read(node *disklayout.ExtentNode, off uint64, dst []byte) (
 // call.
 //
 // A non-nil error indicates that this is a partial read and there is probably
-// more to read from this extent. The caller should propagate the error upward
-// and not move to the next extent in the tree.
-//
-// A subsequent call to extentReader.Read should continue reading from where we
-// left off as expected.
+// more to read from this extent.
 func (f *extentFile) readFromExtent(ex *disklayout.Extent, off uint64, dst []byte) (int, error) {
 	curFileBlk := uint32(off / f.regFile.inode.blkSize)
 	exFirstFileBlk := ex.FileBlock()
-	exLastFileBlk := exFirstFileBlk + uint32(ex.Length) // This is exclusive.
+	exLastFileBlk := exFirstFileBlk + uint32(ex.Len()) // This is exclusive.
 
-	// We should be in this recursive step only if the data we want exists under
-	// the current extent.
+	// We should be here only if the data we want exists under the current
+	// extent.
 	if curFileBlk < exFirstFileBlk || exLastFileBlk <= curFileBlk {
 		panic("searching for a file block in an extent which does not cover it")
 	}
@@ -223,7 +252,7 @@ func (f *extentFile) readFromExtent(ex *disklayout.Extent, off uint64, dst []byt
 	curPhyBlk := uint64(curFileBlk-exFirstFileBlk) + ex.PhysicalBlock()
 	readStart := curPhyBlk*f.regFile.inode.blkSize + (off % f.regFile.inode.blkSize)
 
-	endPhyBlk := ex.PhysicalBlock() + uint64(ex.Length)
+	endPhyBlk := ex.PhysicalBlock() + uint64(ex.Len())
 	extentEnd := endPhyBlk * f.regFile.inode.blkSize // This is exclusive.
 
 	toRead := int(extentEnd - readStart)
@@ -237,3 +266,445 @@ func (f *extentFile) readFromExtent(ex *disklayout.Extent, off uint64, dst []byt
 	}
 	return n, nil
 }
+
+// extentPath is the path from the root of the extent tree to the leaf which
+// covers, or would cover, a file block. nodes[0] is the root, and idxs[i] is
+// the index of the entry of nodes[i] on the path. The index in the leaf is -1
+// if the file block precedes all of its extents.
+type extentPath struct {
+	nodes []*disklayout.ExtentNode
+	idxs  []int
+}
+
+// findPath returns the path to the leaf which covers, or would cover,
+// fileBlk.
+func (f *extentFile) findPath(fileBlk uint32) extentPath {
+	var path extentPath
+	node := &f.root
+	for {
+		found := sort.Search(len(node.Entries), func(i int) bool {
+			return node.Entries[i].Entry.FileBlock() > fileBlk
+		}) - 1
+		path.nodes = append(path.nodes, node)
+		if node.Header.Height == 0 {
+			path.idxs = append(path.idxs, found)
+			return path
+		}
+		// File blocks before the first index belong to its subtree.
+		if found < 0 {
+			found = 0
+		}
+		path.idxs = append(path.idxs, found)
+		node = node.Entries[found].Node
+	}
+}
+
+// nodeBlock returns the physical block holding nodes[depth], which must not
+// be the root.
+func (p *extentPath) nodeBlock(depth int) uint64 {
+	return p.nodes[depth-1].Entries[p.idxs[depth-1]].Entry.PhysicalBlock()
+}
+
+// blockNodeMaxEntries returns the maximum number of entries of extent tree
+// nodes stored in blocks.
+func (f *extentFile) blockNodeMaxEntries() uint16 {
+	return uint16((f.regFile.inode.blkSize - disklayout.ExtentHeaderSize) / disklayout.ExtentEntrySize)
+}
+
+// writeRootLocked writes the root node to the inode, which is written back.
+//
+// Preconditions: inode.mu must be locked for writing.
+func (f *extentFile) writeRootLocked() error {
+	marshalExtentNode(&f.root, f.regFile.inode.diskInode.Data())
+	return f.regFile.inode.writeBackLocked()
+}
+
+// writeBlockNodeLocked writes node to the physical block blk.
+//
+// Preconditions: inode.mu must be locked for writing.
+func (f *extentFile) writeBlockNodeLocked(node *disklayout.ExtentNode, blk uint64) error {
+	in := &f.regFile.inode
+	buf := make([]byte, in.blkSize)
+	marshalExtentNode(node, buf)
+	if in.fs.metadataCsum {
+		tailOff := disklayout.ExtentHeaderSize + int(node.Header.MaxEntries)*disklayout.ExtentEntrySize
+		seed := in.fs.inodeChecksumSeed(in.inodeNum, in.diskInode.Generation())
+		binary.LittleEndian.PutUint32(buf[tailOff:], crc32c(seed, buf[:tailOff]))
+	}
+	return in.fs.writeBlock(blk, buf)
+}
+
+// writeNodeLocked writes the node at the given depth of path.
+//
+// Preconditions: inode.mu must be locked for writing.
+func (f *extentFile) writeNodeLocked(path extentPath, depth int) error {
+	if depth == 0 {
+		return f.writeRootLocked()
+	}
+	return f.writeBlockNodeLocked(path.nodes[depth], path.nodeBlock(depth))
+}
+
+// marshalExtentNode serializes node into buf.
+func marshalExtentNode(node *disklayout.ExtentNode, buf []byte) {
+	node.Header.NumEntries = uint16(len(node.Entries))
+	node.Header.MarshalBytes(buf)
+	off := disklayout.ExtentHeaderSize
+	for _, ep := range node.Entries {
+		ep.Entry.MarshalBytes(buf[off:])
+		off += disklayout.ExtentEntrySize
+	}
+}
+
+// allocNodeBlockLocked allocates a block for an extent tree node, close to the
+// extents of the file.
+//
+// Preconditions: inode.mu must be locked for writing.
+func (f *extentFile) allocNodeBlockLocked(goal uint64) (uint64, error) {
+	blk, _, err := f.regFile.inode.fs.allocBlocks(goal, 1)
+	if err != nil {
+		return 0, err
+	}
+	f.regFile.inode.addBlocksLocked(1)
+	return blk, nil
+}
+
+// correctIndexesLocked updates the keys of the index entries leading to the
+// node at the given depth of path, after its first entry changed. e2fsck
+// requires the key of an index entry to be the first file block of its
+// subtree.
+//
+// Preconditions: inode.mu must be locked for writing.
+func (f *extentFile) correctIndexesLocked(path extentPath, depth int) error {
+	for d := depth; d > 0; d-- {
+		idx := path.nodes[d-1].Entries[path.idxs[d-1]].Entry.(*disklayout.ExtentIdx)
+		idx.FirstFileBlock = path.nodes[d].Entries[0].Entry.FileBlock()
+		if err := f.writeNodeLocked(path, d-1); err != nil {
+			return err
+		}
+		if path.idxs[d-1] != 0 {
+			break
+		}
+	}
+	return nil
+}
+
+// insertEntryLocked inserts entry at index pos of the node at the given depth
+// of path. Full nodes are split, and the tree grows in depth when the root is
+// full.
+//
+// Preconditions: inode.mu must be locked for writing.
+func (f *extentFile) insertEntryLocked(path extentPath, depth int, pos int, entry disklayout.ExtentEntryPair) error {
+	node := path.nodes[depth]
+	node.Entries = append(node.Entries, disklayout.ExtentEntryPair{})
+	copy(node.Entries[pos+1:], node.Entries[pos:])
+	node.Entries[pos] = entry
+
+	if len(node.Entries) <= int(node.Header.MaxEntries) {
+		if err := f.writeNodeLocked(path, depth); err != nil {
+			return err
+		}
+		if pos == 0 {
+			return f.correctIndexesLocked(path, depth)
+		}
+		return nil
+	}
+
+	goal := entry.Entry.PhysicalBlock()
+	if depth == 0 {
+		// Move the entries of the root to a new block, which becomes the only
+		// child of the root.
+		blk, err := f.allocNodeBlockLocked(goal)
+		if err != nil {
+			node.Entries = append(node.Entries[:pos], node.Entries[pos+1:]...)
+			return err
+		}
+		child := &disklayout.ExtentNode{
+			Header: disklayout.ExtentHeader{
+				Magic:      disklayout.ExtentMagic,
+				MaxEntries: f.blockNodeMaxEntries(),
+				Height:     node.Header.Height,
+			},
+			Entries: node.Entries,
+		}
+		if err := f.writeBlockNodeLocked(child, blk); err != nil {
+			return err
+		}
+		idx := &disklayout.ExtentIdx{FirstFileBlock: child.Entries[0].Entry.FileBlock()}
+		idx.SetPhysicalBlock(blk)
+		node.Entries = []disklayout.ExtentEntryPair{{Entry: idx, Node: child}}
+		node.Header.Height++
+		return f.writeRootLocked()
+	}
+
+	// Split the node in two halves. The new node holding the upper half is
+	// written before it replaces these entries in the node.
+	blk, err := f.allocNodeBlockLocked(goal)
+	if err != nil {
+		node.Entries = append(node.Entries[:pos], node.Entries[pos+1:]...)
+		return err
+	}
+	mid := len(node.Entries) / 2
+	right := &disklayout.ExtentNode{
+		Header:  node.Header,
+		Entries: append([]disklayout.ExtentEntryPair(nil), node.Entries[mid:]...),
+	}
+	node.Entries = append([]disklayout.ExtentEntryPair(nil), node.Entries[:mid]...)
+	if err := f.writeBlockNodeLocked(right, blk); err != nil {
+		return err
+	}
+	if err := f.writeNodeLocked(path, depth); err != nil {
+		return err
+	}
+	if pos == 0 {
+		if err := f.correctIndexesLocked(path, depth); err != nil {
+			return err
+		}
+	}
+	idx := &disklayout.ExtentIdx{FirstFileBlock: right.Entries[0].Entry.FileBlock()}
+	idx.SetPhysicalBlock(blk)
+	return f.insertEntryLocked(path, depth-1, path.idxs[depth-1]+1, disklayout.ExtentEntryPair{Entry: idx, Node: right})
+}
+
+// insertExtentLocked maps the count file blocks starting at fileBlk, which
+// must be in a hole, to the physical blocks starting at phyBlk.
+//
+// Preconditions: inode.mu must be locked for writing.
+func (f *extentFile) insertExtentLocked(fileBlk uint32, phyBlk uint64, count uint16) error {
+	path := f.findPath(fileBlk)
+	depth := len(path.nodes) - 1
+	leaf := path.nodes[depth]
+	i := path.idxs[depth]
+
+	// Extend the preceding extent if the new blocks follow it.
+	if i >= 0 {
+		prev := leaf.Entries[i].Entry.(*disklayout.Extent)
+		if !prev.Uninitialized() &&
+			prev.FileBlock()+uint32(prev.Len()) == fileBlk &&
+			prev.PhysicalBlock()+uint64(prev.Len()) == phyBlk &&
+			uint32(prev.Len())+uint32(count) <= disklayout.MaxInitExtentLength {
+			prev.Length += count
+			return f.writeNodeLocked(path, depth)
+		}
+	}
+
+	ex := &disklayout.Extent{FirstFileBlock: fileBlk, Length: count}
+	ex.SetPhysicalBlock(phyBlk)
+	return f.insertEntryLocked(path, depth, i+1, disklayout.ExtentEntryPair{Entry: ex})
+}
+
+// writeAtLocked writes src to the file at offset off. Blocks are allocated to
+// fill holes. The file size is not updated.
+//
+// Preconditions: inode.mu must be locked for writing.
+func (f *extentFile) writeAtLocked(src []byte, off uint64) (int, error) {
+	in := &f.regFile.inode
+	blkSize := in.blkSize
+	written := 0
+	for written < len(src) {
+		cur := off + uint64(written)
+		fileBlk := uint32(cur / blkSize)
+		blkOff := cur % blkSize
+		ex, next := f.findExtent(&f.root, fileBlk)
+
+		if ex != nil && ex.Uninitialized() {
+			// Zero the extent on disk before it is marked as initialized.
+			if err := f.zeroExtentLocked(ex); err != nil {
+				return written, err
+			}
+			ex.Length = ex.Len()
+			path := f.findPath(fileBlk)
+			if err := f.writeNodeLocked(path, len(path.nodes)-1); err != nil {
+				return written, err
+			}
+			continue
+		}
+
+		if ex != nil {
+			// Overwrite mapped blocks in place.
+			exEnd := uint64(ex.FileBlock()+uint32(ex.Len())) * blkSize
+			n := len(src) - written
+			if uint64(n) > exEnd-cur {
+				n = int(exEnd - cur)
+			}
+			phyOff := (ex.PhysicalBlock()+uint64(fileBlk-ex.FileBlock()))*blkSize + blkOff
+			if err := in.fs.writeAt(src[written:written+n], int64(phyOff)); err != nil {
+				return written, err
+			}
+			written += n
+			continue
+		}
+
+		// Fill the hole with newly allocated blocks, as contiguous as
+		// possible. Unwritten parts of the blocks are zeroed.
+		want := (blkOff + uint64(len(src)-written) + blkSize - 1) / blkSize
+		if hole := next - uint64(fileBlk); want > hole {
+			want = hole
+		}
+		if want > disklayout.MaxInitExtentLength {
+			want = disklayout.MaxInitExtentLength
+		}
+		phyBlk, count, err := in.fs.allocBlocks(f.allocGoal(fileBlk), want)
+		if err != nil {
+			return written, err
+		}
+		n := len(src) - written
+		if uint64(n) > count*blkSize-blkOff {
+			n = int(count*blkSize - blkOff)
+		}
+		buf := make([]byte, count*blkSize)
+		copy(buf[blkOff:], src[written:written+n])
+		if err := in.fs.writeAt(buf, int64(phyBlk*blkSize)); err != nil {
+			in.fs.freeBlocks(phyBlk, count)
+			return written, err
+		}
+		if err := f.insertExtentLocked(fileBlk, phyBlk, uint16(count)); err != nil {
+			in.fs.freeBlocks(phyBlk, count)
+			return written, err
+		}
+		in.addBlocksLocked(int64(count))
+		written += n
+	}
+	return written, nil
+}
+
+// allocGoal returns the physical block where data for fileBlk should
+// preferably be allocated: after the blocks of the preceding extent, or in
+// the block group of the inode.
+func (f *extentFile) allocGoal(fileBlk uint32) uint64 {
+	path := f.findPath(fileBlk)
+	depth := len(path.nodes) - 1
+	if i := path.idxs[depth]; i >= 0 {
+		prev := path.nodes[depth].Entries[i].Entry.(*disklayout.Extent)
+		return prev.PhysicalBlock() + uint64(fileBlk-prev.FileBlock())
+	}
+	in := &f.regFile.inode
+	return in.fs.groupFirstBlock(getBGNum(in.inodeNum, in.fs.sb.InodesPerGroup()))
+}
+
+// zeroExtentLocked writes zeros to the physical blocks covered by ex.
+//
+// Preconditions: inode.mu must be locked for writing.
+func (f *extentFile) zeroExtentLocked(ex *disklayout.Extent) error {
+	in := &f.regFile.inode
+	const maxChunkBlocks = 256
+	chunk := uint64(ex.Len())
+	if chunk > maxChunkBlocks {
+		chunk = maxChunkBlocks
+	}
+	zeros := make([]byte, chunk*in.blkSize)
+	for blk, end := ex.PhysicalBlock(), ex.PhysicalBlock()+uint64(ex.Len()); blk < end; blk += chunk {
+		n := end - blk
+		if n > chunk {
+			n = chunk
+		}
+		if err := in.fs.writeAt(zeros[:n*in.blkSize], int64(blk*in.blkSize)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// blockRange is a range of physical blocks.
+type blockRange struct {
+	start uint64
+	count uint64
+}
+
+// truncateLocked sets the size of the file to size. When the file shrinks, the
+// blocks past the end of the file are unmapped, and freed after the inode was
+// written back.
+//
+// Preconditions: inode.mu must be locked for writing.
+func (f *extentFile) truncateLocked(size uint64) error {
+	in := &f.regFile.inode
+	oldSize := in.diskInode.Size()
+	in.diskInode.SetSize(size)
+	if size >= oldSize {
+		return in.writeBackLocked()
+	}
+
+	// Zero the end of the last block, so that it reads as zeros if the file
+	// grows again.
+	if blkOff := size % in.blkSize; blkOff != 0 {
+		fileBlk := uint32(size / in.blkSize)
+		if ex, _ := f.findExtent(&f.root, fileBlk); ex != nil && !ex.Uninitialized() {
+			phyOff := (ex.PhysicalBlock()+uint64(fileBlk-ex.FileBlock()))*in.blkSize + blkOff
+			if err := in.fs.writeAt(make([]byte, in.blkSize-blkOff), int64(phyOff)); err != nil {
+				return err
+			}
+		}
+	}
+
+	from := (size + in.blkSize - 1) / in.blkSize
+	var freed []blockRange
+	if from <= uint64(^uint32(0)) {
+		if err := f.truncateNodeLocked(&f.root, 0, uint32(from), &freed); err != nil {
+			return err
+		}
+	}
+	if len(f.root.Entries) == 0 {
+		f.root.Header.Height = 0
+	}
+	for _, r := range freed {
+		in.addBlocksLocked(-int64(r.count))
+	}
+	if err := f.writeRootLocked(); err != nil {
+		return err
+	}
+	for _, r := range freed {
+		if err := in.fs.freeBlocks(r.start, r.count); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// truncateNodeLocked unmaps the file blocks starting at from in the subtree
+// rooted at node, which is stored in physical block blk (0 for the root).
+// Modified nodes stored in blocks are written back, and the physical blocks
+// to be freed, including those of removed nodes, are appended to freed.
+//
+// Preconditions: inode.mu must be locked for writing.
+func (f *extentFile) truncateNodeLocked(node *disklayout.ExtentNode, blk uint64, from uint32, freed *[]blockRange) error {
+	n := len(node.Entries)
+	changed := false
+	for n > 0 {
+		ep := node.Entries[n-1]
+		if node.Header.Height == 0 {
+			ex := ep.Entry.(*disklayout.Extent)
+			if ex.FileBlock() >= from {
+				*freed = append(*freed, blockRange{ex.PhysicalBlock(), uint64(ex.Len())})
+				n--
+				continue
+			}
+			if keep := from - ex.FileBlock(); keep < uint32(ex.Len()) {
+				*freed = append(*freed, blockRange{ex.PhysicalBlock() + uint64(keep), uint64(uint32(ex.Len()) - keep)})
+				if ex.Uninitialized() {
+					ex.Length = uint16(keep) + disklayout.MaxInitExtentLength
+				} else {
+					ex.Length = uint16(keep)
+				}
+				changed = true
+			}
+			break
+		}
+
+		childBlk := ep.Entry.PhysicalBlock()
+		if err := f.truncateNodeLocked(ep.Node, childBlk, from, freed); err != nil {
+			return err
+		}
+		if len(ep.Node.Entries) != 0 {
+			break
+		}
+		*freed = append(*freed, blockRange{childBlk, 1})
+		n--
+	}
+	if n < len(node.Entries) {
+		node.Entries = node.Entries[:n]
+		changed = true
+	}
+	if blk == 0 || !changed {
+		return nil
+	}
+	return f.writeBlockNodeLocked(node, blk)
+}
@@ -21,6 +21,7 @@ import (
 	"gvisor.dev/gvisor/pkg/abi/linux"
 	"gvisor.dev/gvisor/pkg/context"
 	"gvisor.dev/gvisor/pkg/fspath"
+	"gvisor.dev/gvisor/pkg/log"
 	"gvisor.dev/gvisor/pkg/sentry/fsimpl/ext/disklayout"
 	"gvisor.dev/gvisor/pkg/sentry/kernel/auth"
 	"gvisor.dev/gvisor/pkg/sentry/socket/unix/transport"
@@ -53,6 +54,19 @@ type filesystem struct {
 	// requests in the optimal order (taking locality into consideration).
 	dev io.ReaderAt
 
+	// devWriter writes to the underlying fs device, or is nil if the
+	// filesystem is read-only. Like dev, it does not require protection.
+	devWriter io.WriterAt
+
+	// readOnly is true if the filesystem can not be modified, because the
+	// device is read-only or the filesystem uses unsupported features.
+	// readOnly is immutable.
+	readOnly bool
+
+	// disk is the block device from which the filesystem is read, or nil if
+	// dev is a host file. disk is immutable.
+	disk vfs.Disk `state:"nosave"`
+
 	// inodeCache maps absolute inode numbers to the corresponding Inode struct.
 	// Inodes should be removed from this once their reference count hits 0.
 	//
@@ -61,12 +75,50 @@ type filesystem struct {
 	inodeCache map[uint32]*inode
 
 	// sb represents the filesystem superblock. Immutable after initialization.
+	// The fields that change when the filesystem is modified, like free
+	// counts, are only kept up to date in sbRaw.
 	sb disklayout.SuperBlock
 
-	// bgs represents all the block group descriptors for the filesystem.
-	// Immutable after initialization.
+	// bgs represents all the block group descriptors for the filesystem. The
+	// slice is immutable after initialization, but the counts, flags and
+	// checksums of the descriptors are protected by allocMu.
 	bgs []disklayout.BlockGroup
 
+	// metadataCsum is true if the filesystem has the metadata_csum feature.
+	// Immutable after initialization.
+	metadataCsum bool
+
+	// uninitBG is true if the filesystem has the metadata_csum or gdt_csum
+	// features, with which group descriptors are checksummed and block groups
+	// may have uninitialized bitmaps. Immutable after initialization.
+	uninitBG bool
+
+	// csumSeed is the seed of metadata checksums. Immutable after
+	// initialization.
+	csumSeed uint32
+
+	// allocMu serializes block and inode allocations, and protects the
+	// following fields.
+	//
+	// Lock order:
+	//   filesystem.mu
+	//     directory.mu
+	//       inode.mu
+	//         filesystem.allocMu
+	allocMu sync.Mutex `state:"nosave"`
+
+	// sbRaw is the on-disk superblock.
+	sbRaw []byte
+
+	// freeBlocksCount and freeInodesCount are the numbers of free blocks and
+	// inodes, summed from the group descriptors.
+	freeBlocksCount uint64
+	freeInodesCount uint32
+
+	// inUse is true if the superblock was marked as not cleanly unmounted by
+	// prepareWrite.
+	inUse bool
+
 	// devMinor is this filesystem's device minor number. Immutable after
 	// initialization.
 	devMinor uint32
@@ -121,8 +173,7 @@ func stepLocked(ctx context.Context, rp *vfs.ResolvingPath, vfsd *vfs.Dentry, in
 		child, ok := dir.childCache[name]
 		if !ok {
 			// We may need to instantiate a new dentry for this child.
-			childDirent, ok := dir.childMap[name]
-			if !ok {
+			if _, ok := dir.childMap[name]; !ok {
 				// The underlying inode does not exist on disk.
 				return nil, nil, syserror.ENOENT
 			}
@@ -132,18 +183,11 @@ func stepLocked(ctx context.Context, rp *vfs.ResolvingPath, vfsd *vfs.Dentry, in
 				return nil, nil, errResolveDirent
 			}
 
-			// Create and add the component's dirent to the dentry tree.
 			fs := rp.Mount().Filesystem().Impl().(*filesystem)
-			childInode, err := fs.getOrCreateInodeLocked(childDirent.diskDirent.Inode())
-			if err != nil {
+			var err error
+			if child, err = fs.childLocked(d, name); err != nil {
 				return nil, nil, err
 			}
-			// incRef because this is being added to the dentry tree.
-			childInode.incRef()
-			child = newDentry(childInode)
-			child.parent = d
-			child.name = name
-			dir.childCache[name] = child
 		}
 		if err := rp.CheckMount(ctx, &child.vfsd); err != nil {
 			return nil, nil, err
@@ -185,9 +229,9 @@ func walkLocked(ctx context.Context, rp *vfs.ResolvingPath, write bool) (*vfs.De
 	return vfsd, inode, nil
 }
 
-// walkParentLocked resolves all but the last path component of rp to an
-// existing directory. It does not check that the returned directory is
-// searchable by the provider of rp. The write parameter passed tells if the
+// walkParentLocked resolves all but the last path component of rp, starting
+// from vfsd, to an existing directory. It does not check that the returned
+// directory is searchable by the provider of rp. The write parameter passed tells if the
 // caller has acquired filesystem.mu for writing or not. If set to true,
 // additions can be made to the dentry tree while walking.
 // If errResolveDirent is returned, the walk needs to be continued with an
@@ -198,8 +242,7 @@ func walkLocked(ctx context.Context, rp *vfs.ResolvingPath, write bool) (*vfs.De
 // Preconditions:
 // * filesystem.mu must be locked (for writing if write param is true).
 // * !rp.Done().
-func walkParentLocked(ctx context.Context, rp *vfs.ResolvingPath, write bool) (*vfs.Dentry, *inode, error) {
-	vfsd := rp.Start()
+func walkParentLocked(ctx context.Context, rp *vfs.ResolvingPath, vfsd *vfs.Dentry, write bool) (*vfs.Dentry, *inode, error) {
 	inode := vfsd.Impl().(*dentry).inode
 	for !rp.Final() {
 		var err error
@@ -229,7 +272,7 @@ func (fs *filesystem) walk(ctx context.Context, rp *vfs.ResolvingPath, parent bo
 	// of disk. This reduces congestion (allows concurrent walks).
 	fs.mu.RLock()
 	if parent {
-		vfsd, inode, err = walkParentLocked(ctx, rp, false)
+		vfsd, inode, err = walkParentLocked(ctx, rp, rp.Start(), false)
 	} else {
 		vfsd, inode, err = walkLocked(ctx, rp, false)
 	}
@@ -237,10 +280,12 @@ func (fs *filesystem) walk(ctx context.Context, rp *vfs.ResolvingPath, parent bo
 
 	if err == errResolveDirent {
 		// Upgrade lock and continue walking. Lock upgrading in the middle of the
-		// walk is fine as this is a read only filesystem.
+		// walk is fine: rp only refers to dentries which can not be freed, and
+		// modifications made in the meantime are observed like any concurrent
+		// modification.
 		fs.mu.Lock()
 		if parent {
-			vfsd, inode, err = walkParentLocked(ctx, rp, true)
+			vfsd, inode, err = walkParentLocked(ctx, rp, rp.Start(), true)
 		} else {
 			vfsd, inode, err = walkLocked(ctx, rp, true)
 		}
@@ -269,15 +314,59 @@ func (fs *filesystem) getOrCreateInodeLocked(inodeNum uint32) (*inode, error) {
 	return in, nil
 }
 
+// childLocked returns the dentry of the child of parent with the given name,
+// which must exist on disk. The dentry is instantiated and added to the dentry
+// tree if needed.
+//
+// Precondition: must be holding fs.mu for writing.
+func (fs *filesystem) childLocked(parent *dentry, name string) (*dentry, error) {
+	dir := parent.inode.impl.(*directory)
+	if child, ok := dir.childCache[name]; ok {
+		return child, nil
+	}
+	childDirent, ok := dir.childMap[name]
+	if !ok {
+		return nil, syserror.ENOENT
+	}
+
+	// Create and add the component's dirent to the dentry tree.
+	childInode, err := fs.getOrCreateInodeLocked(childDirent.diskDirent.Inode())
+	if err != nil {
+		return nil, err
+	}
+	// incRef because this is being added to the dentry tree.
+	childInode.incRef()
+	child := newDentry(childInode)
+	child.parent = parent
+	child.name = name
+	dir.childCache[name] = child
+	return child, nil
+}
+
+// dropChildLocked removes the dentry of the child of parent with the given
+// name from the dentry tree, if it was instantiated.
+//
+// Precondition: must be holding fs.mu for writing.
+func (fs *filesystem) dropChildLocked(ctx context.Context, parent *dentry, name string) {
+	dir := parent.inode.impl.(*directory)
+	if child, ok := dir.childCache[name]; ok {
+		delete(dir.childCache, name)
+		child.inode.decRef(ctx)
+	}
+}
+
 // statTo writes the statfs fields to the output parameter.
 func (fs *filesystem) statTo(stat *linux.Statfs) {
+	fs.allocMu.Lock()
+	freeBlocks, freeInodes := fs.freeBlocksCount, fs.freeInodesCount
+	fs.allocMu.Unlock()
 	stat.Type = uint64(fs.sb.Magic())
 	stat.BlockSize = int64(fs.sb.BlockSize())
 	stat.Blocks = fs.sb.BlocksCount()
-	stat.BlocksFree = fs.sb.FreeBlocksCount()
-	stat.BlocksAvailable = fs.sb.FreeBlocksCount()
+	stat.BlocksFree = freeBlocks
+	stat.BlocksAvailable = freeBlocks
 	stat.Files = uint64(fs.sb.InodesCount())
-	stat.FilesFree = uint64(fs.sb.FreeInodesCount())
+	stat.FilesFree = uint64(freeInodes)
 	stat.NameLength = disklayout.MaxFileName
 	stat.FragmentSize = int64(fs.sb.BlockSize())
 	// TODO(b/134676337): Set Statfs.Flags and Statfs.FSID.
@@ -324,16 +413,123 @@ func (fs *filesystem) GetParentDentryAt(ctx context.Context, rp *vfs.ResolvingPa
 
 // OpenAt implements vfs.FilesystemImpl.OpenAt.
 func (fs *filesystem) OpenAt(ctx context.Context, rp *vfs.ResolvingPath, opts vfs.OpenOptions) (*vfs.FileDescription, error) {
-	vfsd, inode, err := fs.walk(ctx, rp, false)
+	if opts.Flags&linux.O_TMPFILE != 0 {
+		// Not yet supported.
+		return nil, syserror.EOPNOTSUPP
+	}
+
+	// Handle O_CREAT and !O_CREAT separately, since in the latter case we
+	// don't need fs.mu for writing.
+	if opts.Flags&linux.O_CREAT == 0 {
+		vfsd, inode, err := fs.walk(ctx, rp, false)
+		if err != nil {
+			return nil, err
+		}
+		if fs.readOnly && (vfs.MayWriteFileWithOpenFlags(opts.Flags) || opts.Flags&linux.O_TRUNC != 0) {
+			return nil, syserror.EROFS
+		}
+		return inode.open(ctx, rp, vfsd, &opts)
+	}
+
+	mustCreate := opts.Flags&linux.O_EXCL != 0
+	start := rp.Start()
+	fs.mu.Lock()
+	unlocked := false
+	unlock := func() {
+		if !unlocked {
+			fs.mu.Unlock()
+			unlocked = true
+		}
+	}
+	defer unlock()
+	if rp.Done() {
+		// Reject attempts to open mount root directory with O_CREAT.
+		if rp.MustBeDir() {
+			return nil, syserror.EISDIR
+		}
+		if mustCreate {
+			return nil, syserror.EEXIST
+		}
+		unlock()
+		return start.Impl().(*dentry).inode.open(ctx, rp, start, &opts)
+	}
+afterTrailingSymlink:
+	parentVFSD, parentInode, err := walkParentLocked(ctx, rp, start, true)
 	if err != nil {
 		return nil, err
 	}
-
-	// EROFS is returned if write access is needed.
-	if vfs.MayWriteFileWithOpenFlags(opts.Flags) || opts.Flags&(linux.O_CREAT|linux.O_EXCL|linux.O_TMPFILE) != 0 {
+	parent := parentVFSD.Impl().(*dentry)
+	// Check for search permission in the parent directory.
+	if err := parentInode.checkPermissions(rp.Credentials(), vfs.MayExec); err != nil {
+		return nil, err
+	}
+	// Reject attempts to open directories with O_CREAT.
+	if rp.MustBeDir() {
+		return nil, syserror.EISDIR
+	}
+	name := rp.Component()
+	if name == "." || name == ".." {
+		return nil, syserror.EISDIR
+	}
+	if len(name) > disklayout.MaxFileName {
+		return nil, syserror.ENAMETOOLONG
+	}
+	// Determine whether or not we need to create a file.
+	parentDir := parentInode.impl.(*directory)
+	if _, ok := parentDir.childMap[name]; !ok {
+		if parentVFSD.IsDead() {
+			return nil, syserror.ENOENT
+		}
+		// Already checked for searchability above; now check for writability.
+		if err := parentInode.checkPermissions(rp.Credentials(), vfs.MayWrite); err != nil {
+			return nil, err
+		}
+		mnt := rp.Mount()
+		if err := fs.beginWrite(ctx, mnt); err != nil {
+			return nil, err
+		}
+		defer mnt.EndWrite()
+		creds := rp.Credentials()
+		diskInode := fs.newDiskInode(ctx, linux.ModeRegular|(opts.Mode&^linux.S_IFMT), creds.EffectiveKUID, creds.EffectiveKGID)
+		if _, err := fs.createChildLocked(ctx, parent, name, diskInode, nil); err != nil {
+			return nil, err
+		}
+		child, err := fs.childLocked(parent, name)
+		if err != nil {
+			return nil, err
+		}
+		unlock()
+		// The file is empty, so O_TRUNC has no effect.
+		opts.Flags &^= linux.O_TRUNC
+		return child.inode.open(ctx, rp, &child.vfsd, &opts)
+	}
+	if mustCreate {
+		return nil, syserror.EEXIST
+	}
+	child, err := fs.childLocked(parent, name)
+	if err != nil {
+		return nil, err
+	}
+	// Is the file mounted over?
+	if err := rp.CheckMount(ctx, &child.vfsd); err != nil {
+		return nil, err
+	}
+	// Do we need to resolve a trailing symlink?
+	if symlink, ok := child.inode.impl.(*symlink); ok && rp.ShouldFollowSymlink() {
+		if err := rp.HandleSymlink(symlink.target); err != nil {
+			return nil, err
+		}
+		start = parentVFSD
+		goto afterTrailingSymlink
+	}
+	if rp.MustBeDir() && !child.inode.isDir() {
+		return nil, syserror.ENOTDIR
+	}
+	if fs.readOnly && (vfs.MayWriteFileWithOpenFlags(opts.Flags) || opts.Flags&linux.O_TRUNC != 0) {
 		return nil, syserror.EROFS
 	}
-	return inode.open(rp, vfsd, &opts)
+	unlock()
+	return child.inode.open(ctx, rp, &child.vfsd, &opts)
 }
 
 // ReadlinkAt implements vfs.FilesystemImpl.ReadlinkAt.
@@ -373,123 +569,550 @@ func (fs *filesystem) StatFSAt(ctx context.Context, rp *vfs.ResolvingPath) (linu
 
 // Release implements vfs.FilesystemImpl.Release.
 func (fs *filesystem) Release(ctx context.Context) {
+	if !fs.readOnly {
+		if err := fs.markClean(ctx); err != nil {
+			log.Warningf("ext fs: failed to mark the filesystem as clean: %v", err)
+		}
+	}
 	fs.vfsfs.VirtualFilesystem().PutAnonBlockDevMinor(fs.devMinor)
+	if fs.disk != nil {
+		fs.disk.DecRef(ctx)
+	}
 }
 
 // Sync implements vfs.FilesystemImpl.Sync.
 func (fs *filesystem) Sync(ctx context.Context) error {
-	// This is a readonly filesystem for now.
-	return nil
+	// All changes are written to the device immediately. Only the superblock
+	// is written back lazily, to update its free block and inode counts.
+	if fs.readOnly {
+		return nil
+	}
+	fs.allocMu.Lock()
+	defer fs.allocMu.Unlock()
+	if !fs.inUse {
+		return nil
+	}
+	return fs.writeSuperBlockLocked(ctx)
 }
 
-// The vfs.FilesystemImpl functions below return EROFS because their respective
-// man pages say that EROFS must be returned if the path resolves to a file on
-// this read-only filesystem.
-
-// LinkAt implements vfs.FilesystemImpl.LinkAt.
-func (fs *filesystem) LinkAt(ctx context.Context, rp *vfs.ResolvingPath, vd vfs.VirtualDentry) error {
-	if rp.Done() {
-		return syserror.EEXIST
+// beginWrite must be called before modifying the filesystem through mnt. If it
+// succeeds, the caller must call mnt.EndWrite once done.
+func (fs *filesystem) beginWrite(ctx context.Context, mnt *vfs.Mount) error {
+	if err := mnt.CheckBeginWrite(); err != nil {
+		return err
 	}
-
-	if _, _, err := fs.walk(ctx, rp, true); err != nil {
+	if fs.readOnly {
+		mnt.EndWrite()
+		return syserror.EROFS
+	}
+	if err := fs.prepareWrite(ctx); err != nil {
+		mnt.EndWrite()
 		return err
 	}
-
-	return syserror.EROFS
+	return nil
 }
 
-// MkdirAt implements vfs.FilesystemImpl.MkdirAt.
-func (fs *filesystem) MkdirAt(ctx context.Context, rp *vfs.ResolvingPath, opts vfs.MkdirOptions) error {
+// doCreateAt checks that a file can be created at rp, and calls create to
+// create it, with fs.mu locked for writing.
+func (fs *filesystem) doCreateAt(ctx context.Context, rp *vfs.ResolvingPath, dir bool, create func(parent *dentry, name string) error) error {
 	if rp.Done() {
 		return syserror.EEXIST
 	}
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	parentVFSD, parentInode, err := walkParentLocked(ctx, rp, rp.Start(), true)
+	if err != nil {
+		return err
+	}
 
-	if _, _, err := fs.walk(ctx, rp, true); err != nil {
+	// Order of checks is important. First check if parent directory can be
+	// executed, then check for existence, and lastly check if mount is writable.
+	if err := parentInode.checkPermissions(rp.Credentials(), vfs.MayExec); err != nil {
+		return err
+	}
+	name := rp.Component()
+	if name == "." || name == ".." {
+		return syserror.EEXIST
+	}
+	if len(name) > disklayout.MaxFileName {
+		return syserror.ENAMETOOLONG
+	}
+	if _, ok := parentInode.impl.(*directory).childMap[name]; ok {
+		return syserror.EEXIST
+	}
+	if !dir && rp.MustBeDir() {
+		return syserror.ENOENT
+	}
+	if parentVFSD.IsDead() {
+		return syserror.ENOENT
+	}
+	mnt := rp.Mount()
+	if err := fs.beginWrite(ctx, mnt); err != nil {
 		return err
 	}
+	defer mnt.EndWrite()
 
-	return syserror.EROFS
+	if err := parentInode.checkPermissions(rp.Credentials(), vfs.MayWrite); err != nil {
+		return err
+	}
+	return create(parentVFSD.Impl().(*dentry), name)
 }
 
-// MknodAt implements vfs.FilesystemImpl.MknodAt.
-func (fs *filesystem) MknodAt(ctx context.Context, rp *vfs.ResolvingPath, opts vfs.MknodOptions) error {
-	if rp.Done() {
-		return syserror.EEXIST
+// createChildLocked creates an inode from diskInode with the given data, and
+// links it in parent with the given name. Directories get their "." and ".."
+// entries.
+//
+// Precondition: must be holding fs.mu for writing.
+func (fs *filesystem) createChildLocked(ctx context.Context, parent *dentry, name string, diskInode disklayout.Inode, data []byte) (*inode, error) {
+	parentDir := parent.inode.impl.(*directory)
+	if err := parentDir.checkWritable(); err != nil {
+		return nil, err
+	}
+	mode := diskInode.Mode()
+	if mode.FileType() == linux.ModeDirectory {
+		diskInode.SetLinksCount(2)
+	} else {
+		diskInode.SetLinksCount(1)
+	}
+	child, err := fs.createInodeLocked(ctx, parent.inode, diskInode, data)
+	if err != nil {
+		return nil, err
 	}
 
-	_, _, err := fs.walk(ctx, rp, true)
+	err = func() error {
+		if childDir, ok := child.impl.(*directory); ok {
+			fileType := disklayout.FileTypeFromMode(linux.ModeDirectory)
+			if err := childDir.addEntry(ctx, ".", child.inodeNum, fileType); err != nil {
+				return err
+			}
+			if err := childDir.addEntry(ctx, "..", parent.inode.inodeNum, fileType); err != nil {
+				return err
+			}
+		}
+		return parentDir.addEntry(ctx, name, child.inodeNum, disklayout.FileTypeFromMode(mode))
+	}()
 	if err != nil {
-		return err
+		// Free the inode.
+		child.incRef()
+		child.mu.Lock()
+		child.diskInode.SetLinksCount(0)
+		child.mu.Unlock()
+		child.decRef(ctx)
+		return nil, err
 	}
 
-	return syserror.EROFS
+	if mode.FileType() == linux.ModeDirectory {
+		// Account for the ".." entry of the child.
+		if err := parent.inode.incLinks(ctx); err != nil {
+			return nil, err
+		}
+	}
+	return child, nil
+}
+
+// maxLinks is the maximum number of links to an inode, which is Linux's
+// EXT4_LINK_MAX.
+const maxLinks = 65000
+
+// LinkAt implements vfs.FilesystemImpl.LinkAt.
+func (fs *filesystem) LinkAt(ctx context.Context, rp *vfs.ResolvingPath, vd vfs.VirtualDentry) error {
+	return fs.doCreateAt(ctx, rp, false /* dir */, func(parent *dentry, name string) error {
+		if rp.Mount() != vd.Mount() {
+			return syserror.EXDEV
+		}
+		in := vd.Dentry().Impl().(*dentry).inode
+		if in.isDir() {
+			return syserror.EPERM
+		}
+		in.mu.RLock()
+		mode, uid, gid, links := in.diskInode.Mode(), in.diskInode.UID(), in.diskInode.GID(), in.diskInode.LinksCount()
+		in.mu.RUnlock()
+		if err := vfs.MayLink(auth.CredentialsFromContext(ctx), mode, uid, gid); err != nil {
+			return err
+		}
+		if links == 0 {
+			return syserror.ENOENT
+		}
+		if links >= maxLinks {
+			return syserror.EMLINK
+		}
+		parentDir := parent.inode.impl.(*directory)
+		if err := parentDir.checkWritable(); err != nil {
+			return err
+		}
+		// Increment the link count first, so that it is never lower than the
+		// number of links on disk.
+		if err := in.incLinks(ctx); err != nil {
+			return err
+		}
+		if err := parentDir.addEntry(ctx, name, in.inodeNum, disklayout.FileTypeFromMode(mode)); err != nil {
+			in.decLinks(ctx)
+			return err
+		}
+		return nil
+	})
+}
+
+// MkdirAt implements vfs.FilesystemImpl.MkdirAt.
+func (fs *filesystem) MkdirAt(ctx context.Context, rp *vfs.ResolvingPath, opts vfs.MkdirOptions) error {
+	return fs.doCreateAt(ctx, rp, true /* dir */, func(parent *dentry, name string) error {
+		parent.inode.mu.RLock()
+		links := parent.inode.diskInode.LinksCount()
+		parent.inode.mu.RUnlock()
+		if links >= maxLinks {
+			return syserror.EMLINK
+		}
+		creds := rp.Credentials()
+		diskInode := fs.newDiskInode(ctx, linux.ModeDirectory|(opts.Mode&^linux.S_IFMT), creds.EffectiveKUID, creds.EffectiveKGID)
+		_, err := fs.createChildLocked(ctx, parent, name, diskInode, nil)
+		return err
+	})
+}
+
+// MknodAt implements vfs.FilesystemImpl.MknodAt.
+func (fs *filesystem) MknodAt(ctx context.Context, rp *vfs.ResolvingPath, opts vfs.MknodOptions) error {
+	return fs.doCreateAt(ctx, rp, false /* dir */, func(parent *dentry, name string) error {
+		switch opts.Mode.FileType() {
+		case 0, linux.S_IFREG:
+		default:
+			// TODO(b/134676337): Support special files.
+			return syserror.EPERM
+		}
+		creds := rp.Credentials()
+		diskInode := fs.newDiskInode(ctx, linux.ModeRegular|(opts.Mode&^linux.S_IFMT), creds.EffectiveKUID, creds.EffectiveKGID)
+		_, err := fs.createChildLocked(ctx, parent, name, diskInode, nil)
+		return err
+	})
 }
 
 // RenameAt implements vfs.FilesystemImpl.RenameAt.
 func (fs *filesystem) RenameAt(ctx context.Context, rp *vfs.ResolvingPath, oldParentVD vfs.VirtualDentry, oldName string, opts vfs.RenameOptions) error {
+	if opts.Flags != 0 {
+		// TODO(b/145974740): Support renameat2 flags.
+		return syserror.EINVAL
+	}
 	if rp.Done() {
 		return syserror.ENOENT
 	}
 
-	_, _, err := fs.walk(ctx, rp, false)
+	// Resolve newParent first to verify that it's on this Mount.
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	newParentVFSD, newParentInode, err := walkParentLocked(ctx, rp, rp.Start(), true)
 	if err != nil {
 		return err
 	}
+	newParent := newParentVFSD.Impl().(*dentry)
+	newParentDir := newParentInode.impl.(*directory)
+	newName := rp.Component()
+	if newName == "." || newName == ".." {
+		return syserror.EBUSY
+	}
+	if len(newName) > disklayout.MaxFileName {
+		return syserror.ENAMETOOLONG
+	}
+	mnt := rp.Mount()
+	if mnt != oldParentVD.Mount() {
+		return syserror.EXDEV
+	}
+	if err := fs.beginWrite(ctx, mnt); err != nil {
+		return err
+	}
+	defer mnt.EndWrite()
 
-	return syserror.EROFS
-}
-
-// RmdirAt implements vfs.FilesystemImpl.RmdirAt.
-func (fs *filesystem) RmdirAt(ctx context.Context, rp *vfs.ResolvingPath) error {
-	_, inode, err := fs.walk(ctx, rp, false)
+	oldParent := oldParentVD.Dentry().Impl().(*dentry)
+	oldParentDir := oldParent.inode.impl.(*directory)
+	if err := oldParent.inode.checkPermissions(rp.Credentials(), vfs.MayWrite|vfs.MayExec); err != nil {
+		return err
+	}
+	renamed, err := fs.childLocked(oldParent, oldName)
 	if err != nil {
 		return err
 	}
+	if err := oldParent.inode.mayDelete(rp.Credentials(), renamed.inode); err != nil {
+		return err
+	}
+	// Note that we don't need to call rp.CheckMount(), since if renamed is a
+	// mount point then we want to rename the mount point, not anything in the
+	// mounted filesystem.
+	renamedDir, renamedIsDir := renamed.inode.impl.(*directory)
+	if renamedIsDir {
+		if renamed == newParent || genericIsAncestorDentry(renamed, newParent) {
+			return syserror.EINVAL
+		}
+		if oldParent != newParent {
+			// Writability is needed to change renamed's "..".
+			if err := renamed.inode.checkPermissions(rp.Credentials(), vfs.MayWrite); err != nil {
+				return err
+			}
+		}
+	} else {
+		if opts.MustBeDir || rp.MustBeDir() {
+			return syserror.ENOTDIR
+		}
+	}
 
-	if !inode.isDir() {
-		return syserror.ENOTDIR
+	if err := newParentInode.checkPermissions(rp.Credentials(), vfs.MayWrite|vfs.MayExec); err != nil {
+		return err
+	}
+	var replaced *dentry
+	if _, ok := newParentDir.childMap[newName]; ok {
+		if replaced, err = fs.childLocked(newParent, newName); err != nil {
+			return err
+		}
+		if err := newParentInode.mayDelete(rp.Credentials(), replaced.inode); err != nil {
+			return err
+		}
+		replacedDir, ok := replaced.inode.impl.(*directory)
+		if ok {
+			if !renamedIsDir {
+				return syserror.EISDIR
+			}
+			if !replacedDir.isEmpty() {
+				return syserror.ENOTEMPTY
+			}
+		} else {
+			if rp.MustBeDir() {
+				return syserror.ENOTDIR
+			}
+			if renamedIsDir {
+				return syserror.ENOTDIR
+			}
+		}
+	} else if renamedIsDir && oldParent != newParent {
+		newParentInode.mu.RLock()
+		links := newParentInode.diskInode.LinksCount()
+		newParentInode.mu.RUnlock()
+		if links >= maxLinks {
+			return syserror.EMLINK
+		}
+	}
+	if newParentVFSD.IsDead() {
+		return syserror.ENOENT
 	}
 
-	return syserror.EROFS
-}
+	// Linux places this check before some of those above; we do it here for
+	// simplicity, under the assumption that applications are not intentionally
+	// doing noop renames expecting them to succeed where non-noop renames
+	// would fail.
+	if replaced != nil && replaced.inode == renamed.inode {
+		return nil
+	}
+	if err := oldParentDir.checkWritable(); err != nil {
+		return err
+	}
+	if err := newParentDir.checkWritable(); err != nil {
+		return err
+	}
+	if renamedIsDir && oldParent != newParent {
+		if err := renamedDir.checkWritable(); err != nil {
+			return err
+		}
+	}
+	vfsObj := rp.VirtualFilesystem()
+	mntns := vfs.MountNamespaceFromContext(ctx)
+	defer mntns.DecRef(ctx)
+	var replacedVFSD *vfs.Dentry
+	if replaced != nil {
+		replacedVFSD = &replaced.vfsd
+	}
+	if err := vfsObj.PrepareRenameDentry(mntns, &renamed.vfsd, replacedVFSD); err != nil {
+		return err
+	}
 
-// SetStatAt implements vfs.FilesystemImpl.SetStatAt.
-func (fs *filesystem) SetStatAt(ctx context.Context, rp *vfs.ResolvingPath, opts vfs.SetStatOptions) error {
-	_, _, err := fs.walk(ctx, rp, false)
+	// Link the new name before unlinking the old one, so that a crash leaves
+	// an extra link rather than losing the file, like Linux.
+	fileType := disklayout.FileTypeFromMode(renamed.inode.diskInode.Mode())
+	if replaced != nil {
+		err = newParentDir.setEntry(ctx, newName, renamed.inode.inodeNum, fileType)
+	} else {
+		err = newParentDir.addEntry(ctx, newName, renamed.inode.inodeNum, fileType)
+	}
 	if err != nil {
+		vfsObj.AbortRenameDentry(&renamed.vfsd, replacedVFSD)
 		return err
 	}
+	if err := oldParentDir.removeEntry(ctx, oldName); err != nil {
+		// The renamed file is now linked under both names, which e2fsck
+		// fixes. Keep the in-memory state consistent with the disk.
+		log.Warningf("ext fs: failed to remove %q after renaming it: %v", oldName, err)
+	}
+	if err := renamed.inode.touchCtime(ctx); err != nil {
+		log.Warningf("ext fs: failed to update the change time of inode %d: %v", renamed.inode.inodeNum, err)
+	}
+	if renamedIsDir {
+		if oldParent != newParent {
+			dirType := disklayout.FileTypeFromMode(linux.ModeDirectory)
+			if err := renamedDir.setEntry(ctx, "..", newParent.inode.inodeNum, dirType); err != nil {
+				log.Warningf("ext fs: failed to update \"..\" of inode %d: %v", renamed.inode.inodeNum, err)
+			}
+		}
+		if err := oldParent.inode.decLinks(ctx); err != nil {
+			log.Warningf("ext fs: failed to update the link count of inode %d: %v", oldParent.inode.inodeNum, err)
+		}
+		if replaced == nil {
+			if err := newParent.inode.incLinks(ctx); err != nil {
+				log.Warningf("ext fs: failed to update the link count of inode %d: %v", newParent.inode.inodeNum, err)
+			}
+		}
+	}
+	if replaced != nil {
+		if err := replaced.inode.unlink(ctx); err != nil {
+			log.Warningf("ext fs: failed to update the link count of inode %d: %v", replaced.inode.inodeNum, err)
+		}
+	}
+	vfsObj.CommitRenameReplaceDentry(ctx, &renamed.vfsd, replacedVFSD)
 
-	return syserror.EROFS
+	// Update the dentry tree.
+	delete(oldParentDir.childCache, oldName)
+	if replaced != nil {
+		fs.dropChildLocked(ctx, newParent, newName)
+	}
+	newParentDir.childCache[newName] = renamed
+	renamed.parent = newParent
+	renamed.name = newName
+	return nil
 }
 
-// SymlinkAt implements vfs.FilesystemImpl.SymlinkAt.
-func (fs *filesystem) SymlinkAt(ctx context.Context, rp *vfs.ResolvingPath, target string) error {
+// RmdirAt implements vfs.FilesystemImpl.RmdirAt.
+func (fs *filesystem) RmdirAt(ctx context.Context, rp *vfs.ResolvingPath) error {
+	return fs.doDeleteAt(ctx, rp, true /* dir */)
+}
+
+// UnlinkAt implements vfs.FilesystemImpl.UnlinkAt.
+func (fs *filesystem) UnlinkAt(ctx context.Context, rp *vfs.ResolvingPath) error {
+	return fs.doDeleteAt(ctx, rp, false /* dir */)
+}
+
+// doDeleteAt implements RmdirAt if dir is true, and UnlinkAt otherwise.
+func (fs *filesystem) doDeleteAt(ctx context.Context, rp *vfs.ResolvingPath, dir bool) error {
 	if rp.Done() {
-		return syserror.EEXIST
+		if dir {
+			return syserror.EBUSY
+		}
+		return syserror.EISDIR
 	}
-
-	_, _, err := fs.walk(ctx, rp, true)
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	parentVFSD, parentInode, err := walkParentLocked(ctx, rp, rp.Start(), true)
 	if err != nil {
 		return err
 	}
+	parent := parentVFSD.Impl().(*dentry)
+	if err := parentInode.checkPermissions(rp.Credentials(), vfs.MayWrite|vfs.MayExec); err != nil {
+		return err
+	}
+	name := rp.Component()
+	if dir {
+		if name == "." {
+			return syserror.EINVAL
+		}
+		if name == ".." {
+			return syserror.ENOTEMPTY
+		}
+	} else if name == "." || name == ".." {
+		return syserror.EISDIR
+	}
+	child, err := fs.childLocked(parent, name)
+	if err != nil {
+		return err
+	}
+	if err := parentInode.mayDelete(rp.Credentials(), child.inode); err != nil {
+		return err
+	}
+	if dir {
+		childDir, ok := child.inode.impl.(*directory)
+		if !ok {
+			return syserror.ENOTDIR
+		}
+		if !childDir.isEmpty() {
+			return syserror.ENOTEMPTY
+		}
+	} else {
+		if child.inode.isDir() {
+			return syserror.EISDIR
+		}
+		if rp.MustBeDir() {
+			return syserror.ENOTDIR
+		}
+	}
+	mnt := rp.Mount()
+	if err := fs.beginWrite(ctx, mnt); err != nil {
+		return err
+	}
+	defer mnt.EndWrite()
+	parentDir := parentInode.impl.(*directory)
+	if err := parentDir.checkWritable(); err != nil {
+		return err
+	}
+	vfsObj := rp.VirtualFilesystem()
+	mntns := vfs.MountNamespaceFromContext(ctx)
+	defer mntns.DecRef(ctx)
+	if err := vfsObj.PrepareDeleteDentry(mntns, &child.vfsd); err != nil {
+		return err
+	}
 
-	return syserror.EROFS
+	// Remove the dirent before decrementing the link count, so that the link
+	// count is never lower than the number of links on disk.
+	if err := parentDir.removeEntry(ctx, name); err != nil {
+		vfsObj.AbortDeleteDentry(&child.vfsd)
+		return err
+	}
+	if err := child.inode.unlink(ctx); err != nil {
+		log.Warningf("ext fs: failed to update the link count of inode %d: %v", child.inode.inodeNum, err)
+	}
+	if dir {
+		if err := parentInode.decLinks(ctx); err != nil {
+			log.Warningf("ext fs: failed to update the link count of inode %d: %v", parentInode.inodeNum, err)
+		}
+	}
+	vfsObj.CommitDeleteDentry(ctx, &child.vfsd)
+	fs.dropChildLocked(ctx, parent, name)
+	return nil
 }
 
-// UnlinkAt implements vfs.FilesystemImpl.UnlinkAt.
-func (fs *filesystem) UnlinkAt(ctx context.Context, rp *vfs.ResolvingPath) error {
+// SetStatAt implements vfs.FilesystemImpl.SetStatAt.
+func (fs *filesystem) SetStatAt(ctx context.Context, rp *vfs.ResolvingPath, opts vfs.SetStatOptions) error {
 	_, inode, err := fs.walk(ctx, rp, false)
 	if err != nil {
 		return err
 	}
-
-	if inode.isDir() {
-		return syserror.EISDIR
+	if opts.Stat.Mask == 0 {
+		return nil
+	}
+	mnt := rp.Mount()
+	if err := mnt.CheckBeginWrite(); err != nil {
+		return err
 	}
+	defer mnt.EndWrite()
+	return inode.setStat(ctx, rp.Credentials(), &opts)
+}
 
-	return syserror.EROFS
+// SymlinkAt implements vfs.FilesystemImpl.SymlinkAt.
+func (fs *filesystem) SymlinkAt(ctx context.Context, rp *vfs.ResolvingPath, target string) error {
+	return fs.doCreateAt(ctx, rp, false /* dir */, func(parent *dentry, name string) error {
+		if len(target) >= int(fs.sb.BlockSize()) {
+			return syserror.ENAMETOOLONG
+		}
+		creds := rp.Credentials()
+		diskInode := fs.newDiskInode(ctx, linux.ModeSymlink|0777, creds.EffectiveKUID, creds.EffectiveKGID)
+		var data []byte
+		if len(target) < len(diskInode.Data()) {
+			// Fast symlinks store their target in the inode.
+			copy(diskInode.Data(), target)
+			diskInode.SetSize(uint64(len(target)))
+		} else {
+			diskInode.SetFlags(disklayout.InodeFlags{Extents: true})
+			header := disklayout.ExtentHeader{
+				Magic:      disklayout.ExtentMagic,
+				MaxEntries: disklayout.ExtentRootMaxEntries,
+			}
+			header.MarshalBytes(diskInode.Data())
+			data = []byte(target)
+		}
+		_, err := fs.createChildLocked(ctx, parent, name, diskInode, data)
+		return err
+	})
 }
 
 // BoundEndpointAt implements vfs.FilesystemImpl.BoundEndpointAt.
@@ -15,13 +15,19 @@
 package ext
 
 import (
+	"encoding/binary"
 	"fmt"
 	"sync/atomic"
 
 	"gvisor.dev/gvisor/pkg/abi/linux"
+	"gvisor.dev/gvisor/pkg/context"
+	"gvisor.dev/gvisor/pkg/log"
+	"gvisor.dev/gvisor/pkg/rand"
 	"gvisor.dev/gvisor/pkg/sentry/fsimpl/ext/disklayout"
 	"gvisor.dev/gvisor/pkg/sentry/kernel/auth"
+	ktime "gvisor.dev/gvisor/pkg/sentry/kernel/time"
 	"gvisor.dev/gvisor/pkg/sentry/vfs"
+	"gvisor.dev/gvisor/pkg/sync"
 	"gvisor.dev/gvisor/pkg/syserror"
 )
 
@@ -53,7 +59,13 @@ type inode struct {
 	// blkSize is the fs data block size. Same as filesystem.sb.BlockSize().
 	blkSize uint64
 
-	// diskInode gives us access to the inode struct on disk. Immutable.
+	// mu protects the fields of diskInode and the contents of the file
+	// represented by the inode, including the extent tree of extent files.
+	// diskInode itself is immutable.
+	mu sync.RWMutex `state:"nosave"`
+
+	// diskInode gives us access to the inode struct on disk. Changes are
+	// written back to disk by writeBackLocked.
 	diskInode disklayout.Inode
 
 	locks vfs.FileLocks
@@ -82,12 +94,19 @@ func (in *inode) tryIncRef() bool {
 }
 
 // decRef decrements the inode ref count and releases the inode resources if
-// the ref count hits 0.
+// the ref count hits 0. If the inode was unlinked, it is freed on disk.
 //
 // Precondition: Must have locked filesystem.mu.
-func (in *inode) decRef() {
+func (in *inode) decRef(ctx context.Context) {
 	if refs := atomic.AddInt64(&in.refs, -1); refs == 0 {
 		delete(in.fs.inodeCache, in.inodeNum)
+		in.mu.Lock()
+		defer in.mu.Unlock()
+		if !in.fs.readOnly && in.diskInode.LinksCount() == 0 {
+			if err := in.evictLocked(ctx); err != nil {
+				log.Warningf("ext fs: failed to free inode %d: %v", in.inodeNum, err)
+			}
+		}
 	} else if refs < 0 {
 		panic("ext.inode.decRef() called without holding a reference")
 	}
@@ -108,21 +127,20 @@ func newInode(fs *filesystem, inodeNum uint32) (*inode, error) {
 		diskInode = &disklayout.InodeNew{}
 	}
 
-	// Calculate where the inode is actually placed.
-	inodesPerGrp := fs.sb.InodesPerGroup()
-	blkSize := fs.sb.BlockSize()
-	inodeTableOff := fs.bgs[getBGNum(inodeNum, inodesPerGrp)].InodeTable() * blkSize
-	inodeOff := inodeTableOff + uint64(uint32(inodeRecordSize)*getBGOff(inodeNum, inodesPerGrp))
-
-	if err := readFromDisk(fs.dev, int64(inodeOff), diskInode); err != nil {
+	if err := readFromDisk(fs.dev, fs.inodeOffset(inodeNum), diskInode); err != nil {
 		return nil, err
 	}
+	return newInodeFromDisk(fs, inodeNum, diskInode)
+}
 
+// newInodeFromDisk builds the inode with the given number from its on-disk
+// representation.
+func newInodeFromDisk(fs *filesystem, inodeNum uint32, diskInode disklayout.Inode) (*inode, error) {
 	// Build the inode based on its type.
 	args := inodeArgs{
 		fs:        fs,
 		inodeNum:  inodeNum,
-		blkSize:   blkSize,
+		blkSize:   fs.sb.BlockSize(),
 		diskInode: diskInode,
 	}
 
@@ -151,6 +169,14 @@ func newInode(fs *filesystem, inodeNum uint32) (*inode, error) {
 	}
 }
 
+// inodeOffset returns the absolute offset of the record of the inode with the
+// given number in its block group's inode table.
+func (fs *filesystem) inodeOffset(inodeNum uint32) int64 {
+	inodesPerGrp := fs.sb.InodesPerGroup()
+	inodeTableOff := fs.bgs[getBGNum(inodeNum, inodesPerGrp)].InodeTable() * fs.sb.BlockSize()
+	return int64(inodeTableOff + uint64(fs.sb.InodeSize())*uint64(getBGOff(inodeNum, inodesPerGrp)))
+}
+
 type inodeArgs struct {
 	fs        *filesystem
 	inodeNum  uint32
@@ -167,7 +193,7 @@ func (in *inode) init(args inodeArgs, impl interface{}) {
 }
 
 // open creates and returns a file description for the dentry passed in.
-func (in *inode) open(rp *vfs.ResolvingPath, vfsd *vfs.Dentry, opts *vfs.OpenOptions) (*vfs.FileDescription, error) {
+func (in *inode) open(ctx context.Context, rp *vfs.ResolvingPath, vfsd *vfs.Dentry, opts *vfs.OpenOptions) (*vfs.FileDescription, error) {
 	ats := vfs.AccessTypesForOpenFlags(opts)
 	if err := in.checkPermissions(rp.Credentials(), ats); err != nil {
 		return nil, err
@@ -180,10 +206,21 @@ func (in *inode) open(rp *vfs.ResolvingPath, vfsd *vfs.Dentry, opts *vfs.OpenOpt
 		if err := fd.vfsfd.Init(&fd, opts.Flags, mnt, vfsd, &vfs.FileDescriptionOptions{}); err != nil {
 			return nil, err
 		}
+		if opts.Flags&linux.O_TRUNC != 0 && ats&vfs.MayWrite != 0 {
+			if err := mnt.CheckBeginWrite(); err != nil {
+				fd.vfsfd.DecRef(ctx)
+				return nil, err
+			}
+			err := in.truncate(ctx, 0)
+			mnt.EndWrite()
+			if err != nil {
+				fd.vfsfd.DecRef(ctx)
+				return nil, err
+			}
+		}
 		return &fd.vfsfd, nil
 	case *directory:
-		// Can't open directories writably. This check is not necessary for a read
-		// only filesystem but will be required when write is implemented.
+		// Can't open directories writably.
 		if ats&vfs.MayWrite != 0 {
 			return nil, syserror.EISDIR
 		}
@@ -210,6 +247,8 @@ func (in *inode) open(rp *vfs.ResolvingPath, vfsd *vfs.Dentry, opts *vfs.OpenOpt
 }
 
 func (in *inode) checkPermissions(creds *auth.Credentials, ats vfs.AccessTypes) error {
+	in.mu.RLock()
+	defer in.mu.RUnlock()
 	return vfs.GenericCheckPermissions(creds, ats, in.diskInode.Mode(), in.diskInode.UID(), in.diskInode.GID())
 }
 
@@ -218,6 +257,8 @@ func (in *inode) statTo(stat *linux.Statx) {
 	stat.Mask = linux.STATX_TYPE | linux.STATX_MODE | linux.STATX_NLINK |
 		linux.STATX_UID | linux.STATX_GID | linux.STATX_INO | linux.STATX_SIZE |
 		linux.STATX_ATIME | linux.STATX_CTIME | linux.STATX_MTIME
+	in.mu.RLock()
+	defer in.mu.RUnlock()
 	stat.Blksize = uint32(in.blkSize)
 	stat.Mode = uint16(in.diskInode.Mode())
 	stat.Nlink = uint32(in.diskInode.LinksCount())
@@ -244,3 +285,343 @@ func getBGNum(inodeNum uint32, inodesPerGrp uint32) uint32 {
 func getBGOff(inodeNum uint32, inodesPerGrp uint32) uint32 {
 	return (inodeNum - 1) % inodesPerGrp
 }
+
+// Offsets of the checksum fields in inode records.
+const (
+	inodeChecksumLoOff = 0x7C
+	inodeChecksumHiOff = 0x82
+)
+
+// writeInode writes diskInode to the record of the inode with the given
+// number. The parts of the record past diskInode, such as in-inode extended
+// attributes, are preserved, unless fresh is true in which case they are
+// zeroed.
+func (fs *filesystem) writeInode(inodeNum uint32, diskInode disklayout.Inode, fresh bool) error {
+	off := fs.inodeOffset(inodeNum)
+	buf := make([]byte, fs.sb.InodeSize())
+	if !fresh {
+		if n, _ := fs.dev.ReadAt(buf, off); n < len(buf) {
+			return syserror.EIO
+		}
+	}
+	raw := make([]byte, diskInode.SizeBytes())
+	diskInode.MarshalBytes(raw)
+	n := int(diskInode.InodeSize())
+	if n > len(buf) {
+		n = len(buf)
+	}
+	copy(buf, raw[:n])
+
+	if fs.metadataCsum {
+		// The checksum covers the whole record, with the checksum fields
+		// zeroed.
+		hasHi := n >= inodeChecksumHiOff+2
+		binary.LittleEndian.PutUint16(buf[inodeChecksumLoOff:], 0)
+		if hasHi {
+			binary.LittleEndian.PutUint16(buf[inodeChecksumHiOff:], 0)
+		}
+		csum := fs.inodeChecksum(inodeNum, diskInode.Generation(), buf)
+		diskInode.SetChecksum(csum)
+		binary.LittleEndian.PutUint16(buf[inodeChecksumLoOff:], uint16(csum))
+		if hasHi {
+			binary.LittleEndian.PutUint16(buf[inodeChecksumHiOff:], uint16(csum>>16))
+		}
+	}
+	return fs.writeAt(buf, off)
+}
+
+// writeBackLocked writes the inode back to disk.
+//
+// Preconditions: in.mu must be locked for writing.
+func (in *inode) writeBackLocked() error {
+	return in.fs.writeInode(in.inodeNum, in.diskInode, false /* fresh */)
+}
+
+// touchCMtimeLocked sets the change and modification times to now.
+//
+// Preconditions: in.mu must be locked for writing.
+func (in *inode) touchCMtimeLocked(ctx context.Context) {
+	now := ktime.NowFromContext(ctx)
+	in.diskInode.SetChangeTime(now)
+	in.diskInode.SetModificationTime(now)
+}
+
+// touchCtimeLocked sets the change time to now.
+//
+// Preconditions: in.mu must be locked for writing.
+func (in *inode) touchCtimeLocked(ctx context.Context) {
+	in.diskInode.SetChangeTime(ktime.NowFromContext(ctx))
+}
+
+// addBlocksLocked adds n filesystem blocks, which may be negative, to the
+// number of blocks used by the inode.
+//
+// Preconditions: in.mu must be locked for writing.
+func (in *inode) addBlocksLocked(n int64) {
+	units := int64(in.blkSize / 512)
+	if in.diskInode.Flags().HugeFile {
+		units = 1
+	}
+	in.diskInode.SetBlocksCount(uint64(int64(in.diskInode.BlocksCount()) + n*units))
+}
+
+// data returns the file holding the data of the inode, or nil if the data is
+// stored in the inode itself.
+func (in *inode) data() *regularFile {
+	switch impl := in.impl.(type) {
+	case *regularFile:
+		return impl
+	case *directory:
+		return impl.data
+	case *symlink:
+		return impl.data
+	default:
+		return nil
+	}
+}
+
+// truncate sets the size of the regular file represented by the inode.
+func (in *inode) truncate(ctx context.Context, size uint64) error {
+	in.mu.Lock()
+	defer in.mu.Unlock()
+	if size == in.diskInode.Size() {
+		return nil
+	}
+	if err := in.fs.prepareWrite(ctx); err != nil {
+		return err
+	}
+	if err := in.data().truncateLocked(size); err != nil {
+		return err
+	}
+	in.touchCMtimeLocked(ctx)
+	return in.writeBackLocked()
+}
+
+// setStat implements vfs.FileDescriptionImpl.SetStat and
+// vfs.FilesystemImpl.SetStatAt.
+func (in *inode) setStat(ctx context.Context, creds *auth.Credentials, opts *vfs.SetStatOptions) error {
+	stat := &opts.Stat
+	if stat.Mask == 0 {
+		return nil
+	}
+	if stat.Mask&^(linux.STATX_MODE|linux.STATX_UID|linux.STATX_GID|linux.STATX_SIZE|linux.STATX_ATIME|linux.STATX_MTIME) != 0 {
+		return syserror.EPERM
+	}
+	if in.fs.readOnly {
+		return syserror.EROFS
+	}
+
+	in.mu.Lock()
+	defer in.mu.Unlock()
+	if err := vfs.CheckSetStat(ctx, creds, opts, in.diskInode.Mode(), in.diskInode.UID(), in.diskInode.GID()); err != nil {
+		return err
+	}
+	if err := in.fs.prepareWrite(ctx); err != nil {
+		return err
+	}
+
+	now := ktime.NowFromContext(ctx)
+	if stat.Mask&linux.STATX_SIZE != 0 {
+		switch in.impl.(type) {
+		case *regularFile:
+			if stat.Size != in.diskInode.Size() {
+				if err := in.data().truncateLocked(stat.Size); err != nil {
+					return err
+				}
+				in.diskInode.SetModificationTime(now)
+			}
+		case *directory:
+			return syserror.EISDIR
+		default:
+			return syserror.EINVAL
+		}
+	}
+	if stat.Mask&linux.STATX_MODE != 0 {
+		in.diskInode.SetMode(in.diskInode.Mode().FileType() | linux.FileMode(stat.Mode&^linux.S_IFMT))
+	}
+	if stat.Mask&linux.STATX_UID != 0 {
+		in.diskInode.SetUID(auth.KUID(stat.UID))
+	}
+	if stat.Mask&linux.STATX_GID != 0 {
+		in.diskInode.SetGID(auth.KGID(stat.GID))
+	}
+	if stat.Mask&linux.STATX_ATIME != 0 {
+		if stat.Atime.Nsec == linux.UTIME_NOW {
+			in.diskInode.SetAccessTime(now)
+		} else {
+			in.diskInode.SetAccessTime(ktime.FromUnix(stat.Atime.Sec, int64(stat.Atime.Nsec)))
+		}
+	}
+	if stat.Mask&linux.STATX_MTIME != 0 {
+		if stat.Mtime.Nsec == linux.UTIME_NOW {
+			in.diskInode.SetModificationTime(now)
+		} else {
+			in.diskInode.SetModificationTime(ktime.FromUnix(stat.Mtime.Sec, int64(stat.Mtime.Nsec)))
+		}
+	}
+	in.diskInode.SetChangeTime(now)
+	return in.writeBackLocked()
+}
+
+// mayDelete checks that the directory represented by in allows child to be
+// deleted from it.
+func (in *inode) mayDelete(creds *auth.Credentials, child *inode) error {
+	in.mu.RLock()
+	mode := in.diskInode.Mode()
+	in.mu.RUnlock()
+	child.mu.RLock()
+	childUID := child.diskInode.UID()
+	child.mu.RUnlock()
+	return vfs.CheckDeleteSticky(creds, mode, childUID)
+}
+
+// touchCtime sets the change time of the inode to now, and writes it back.
+func (in *inode) touchCtime(ctx context.Context) error {
+	in.mu.Lock()
+	defer in.mu.Unlock()
+	in.touchCtimeLocked(ctx)
+	return in.writeBackLocked()
+}
+
+// incLinks increments the link count of the inode, and writes it back.
+//
+// Like Linux with the dir_nlink feature, the link count of directories with
+// more than maxLinks links is set to 1, meaning that it is not known.
+func (in *inode) incLinks(ctx context.Context) error {
+	in.mu.Lock()
+	defer in.mu.Unlock()
+	links := in.diskInode.LinksCount()
+	if in.isDir() && (links == 1 || links >= maxLinks-1) {
+		links = 1
+	} else {
+		links++
+	}
+	in.diskInode.SetLinksCount(links)
+	in.touchCtimeLocked(ctx)
+	return in.writeBackLocked()
+}
+
+// decLinks decrements the link count of the inode, and writes it back. The
+// link count of directories is not decremented below 2, or if it is not known.
+func (in *inode) decLinks(ctx context.Context) error {
+	in.mu.Lock()
+	defer in.mu.Unlock()
+	links := in.diskInode.LinksCount()
+	if !in.isDir() || links > 2 {
+		in.diskInode.SetLinksCount(links - 1)
+	}
+	in.touchCtimeLocked(ctx)
+	return in.writeBackLocked()
+}
+
+// unlink updates the link count of the inode after one of its links was
+// removed. Directories lose all their links, since they can't have more than
+// one. The inode is evicted once it has no links and is no longer referenced.
+func (in *inode) unlink(ctx context.Context) error {
+	if !in.isDir() {
+		return in.decLinks(ctx)
+	}
+	in.mu.Lock()
+	defer in.mu.Unlock()
+	in.diskInode.SetLinksCount(0)
+	in.touchCtimeLocked(ctx)
+	return in.writeBackLocked()
+}
+
+// evictLocked frees the data blocks and the inode on disk. It is called when
+// the inode has no links and is no longer referenced.
+//
+// Preconditions: in.mu must be locked for writing.
+func (in *inode) evictLocked(ctx context.Context) error {
+	if rf := in.data(); rf != nil {
+		if err := rf.truncateLocked(0); err != nil {
+			return err
+		}
+	}
+	if clock := ktime.RealtimeClockFromContext(ctx); clock != nil {
+		in.diskInode.SetDeletionTime(clock.Now())
+	}
+	if err := in.writeBackLocked(); err != nil {
+		return err
+	}
+	return in.fs.freeInode(in.inodeNum, in.isDir())
+}
+
+// newDiskInode returns a new on-disk inode with the given mode and owner,
+// whose times are set to now. Regular files and directories use extents.
+func (fs *filesystem) newDiskInode(ctx context.Context, mode linux.FileMode, uid auth.KUID, gid auth.KGID) disklayout.Inode {
+	var diskInode disklayout.Inode
+	if fs.sb.InodeSize() == disklayout.OldInodeSize {
+		diskInode = &disklayout.InodeOld{}
+	} else {
+		// Use all the fields of InodeNew if they fit in the record, like
+		// Linux does by default.
+		extraSize := uint16((&disklayout.InodeNew{}).SizeBytes()) - disklayout.OldInodeSize
+		if max := fs.sb.InodeSize() - disklayout.OldInodeSize; extraSize > max {
+			extraSize = max
+		}
+		diskInode = &disklayout.InodeNew{ExtraInodeSize: extraSize}
+	}
+	diskInode.SetMode(mode)
+	diskInode.SetUID(uid)
+	diskInode.SetGID(gid)
+	now := ktime.NowFromContext(ctx)
+	diskInode.SetAccessTime(now)
+	diskInode.SetChangeTime(now)
+	diskInode.SetModificationTime(now)
+
+	var gen [4]byte
+	rand.Read(gen[:])
+	diskInode.SetGeneration(binary.LittleEndian.Uint32(gen[:]))
+
+	if ft := mode.FileType(); ft == linux.ModeRegular || ft == linux.ModeDirectory {
+		diskInode.SetFlags(disklayout.InodeFlags{Extents: true})
+		header := disklayout.ExtentHeader{
+			Magic:      disklayout.ExtentMagic,
+			MaxEntries: disklayout.ExtentRootMaxEntries,
+		}
+		header.MarshalBytes(diskInode.Data())
+	}
+	return diskInode
+}
+
+// createInodeLocked allocates an inode, preferably in the block group of
+// parent, writes diskInode to it and returns the new inode. If data is not
+// nil, it is written as the contents of the inode, which must use extents.
+// The returned inode has no references.
+//
+// Preconditions: fs.mu must be locked for writing.
+func (fs *filesystem) createInodeLocked(ctx context.Context, parent *inode, diskInode disklayout.Inode, data []byte) (*inode, error) {
+	isDir := diskInode.Mode().FileType() == linux.ModeDirectory
+	inodeNum, err := fs.allocInode(getBGNum(parent.inodeNum, fs.sb.InodesPerGroup()), isDir)
+	if err != nil {
+		return nil, err
+	}
+	if err := fs.writeInode(inodeNum, diskInode, true /* fresh */); err != nil {
+		fs.freeInode(inodeNum, isDir)
+		return nil, err
+	}
+	if data != nil {
+		// The inode is not visible yet, so there is no need to lock it.
+		rf, err := newRegularFile(inodeArgs{
+			fs:        fs,
+			inodeNum:  inodeNum,
+			blkSize:   fs.sb.BlockSize(),
+			diskInode: diskInode,
+		})
+		if err != nil {
+			fs.freeInode(inodeNum, isDir)
+			return nil, err
+		}
+		if _, err := rf.writeAtLocked(ctx, data, 0); err != nil {
+			fs.freeInode(inodeNum, isDir)
+			return nil, err
+		}
+	}
+	in, err := newInodeFromDisk(fs, inodeNum, diskInode)
+	if err != nil {
+		return nil, err
+	}
+	fs.inodeCache[inodeNum] = in
+	return in, nil
+}
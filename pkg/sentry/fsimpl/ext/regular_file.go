@@ -15,7 +15,9 @@
 package ext
 
 import (
+	"fmt"
 	"io"
+	"math"
 
 	"gvisor.dev/gvisor/pkg/abi/linux"
 	"gvisor.dev/gvisor/pkg/context"
@@ -61,6 +63,44 @@ func newRegularFile(args inodeArgs) (*regularFile, error) {
 	return &file.regFile, nil
 }
 
+// writeAtLocked writes src to the file at offset off, and extends the file if
+// needed. Only extent files can be written to.
+//
+// Preconditions: inode.mu must be locked for writing.
+func (rf *regularFile) writeAtLocked(ctx context.Context, src []byte, off uint64) (int, error) {
+	f, ok := rf.impl.(*extentFile)
+	if !ok {
+		return 0, syserror.EOPNOTSUPP
+	}
+	n, err := f.writeAtLocked(src, off)
+	if n == 0 {
+		return 0, err
+	}
+	in := &rf.inode
+	if end := off + uint64(n); end > in.diskInode.Size() {
+		in.diskInode.SetSize(end)
+	}
+	in.touchCMtimeLocked(ctx)
+	if werr := in.writeBackLocked(); werr != nil {
+		return 0, werr
+	}
+	return n, err
+}
+
+// truncateLocked sets the size of the file to size.
+//
+// Preconditions: inode.mu must be locked for writing.
+func (rf *regularFile) truncateLocked(size uint64) error {
+	switch f := rf.impl.(type) {
+	case *extentFile:
+		return f.truncateLocked(size)
+	case *blockMapFile:
+		return f.truncateLocked(size)
+	default:
+		panic(fmt.Sprintf("unknown regular file type: %T", rf.impl))
+	}
+}
+
 func (in *inode) isRegular() bool {
 	_, ok := in.impl.(*regularFile)
 	return ok
@@ -86,8 +126,11 @@ func (fd *regularFileFD) Release(context.Context) {}
 
 // PRead implements vfs.FileDescriptionImpl.PRead.
 func (fd *regularFileFD) PRead(ctx context.Context, dst usermem.IOSequence, offset int64, opts vfs.ReadOptions) (int64, error) {
+	in := fd.inode()
+	in.mu.RLock()
+	defer in.mu.RUnlock()
 	safeReader := safemem.FromIOReaderAt{
-		ReaderAt: fd.inode().impl.(*regularFile).impl,
+		ReaderAt: in.impl.(*regularFile).impl,
 		Offset:   offset,
 	}
 
@@ -108,16 +151,74 @@ func (fd *regularFileFD) Read(ctx context.Context, dst usermem.IOSequence, opts
 
 // PWrite implements vfs.FileDescriptionImpl.PWrite.
 func (fd *regularFileFD) PWrite(ctx context.Context, src usermem.IOSequence, offset int64, opts vfs.WriteOptions) (int64, error) {
-	// write(2) specifies that EBADF must be returned if the fd is not open for
-	// writing.
-	return 0, syserror.EBADF
+	n, _, err := fd.pwrite(ctx, src, offset, opts)
+	return n, err
+}
+
+// pwrite returns the number of bytes written, final offset and error. The
+// final offset should be ignored by PWrite.
+func (fd *regularFileFD) pwrite(ctx context.Context, src usermem.IOSequence, offset int64, opts vfs.WriteOptions) (written, finalOff int64, err error) {
+	if offset < 0 {
+		return 0, offset, syserror.EINVAL
+	}
+
+	// Check that flags are supported. RWF_DSYNC/RWF_SYNC can be ignored since
+	// all state is written to disk immediately.
+	//
+	// TODO(gvisor.dev/issue/2601): Support select preadv2 flags.
+	if opts.Flags&^(linux.RWF_HIPRI|linux.RWF_DSYNC|linux.RWF_SYNC) != 0 {
+		return 0, offset, syserror.EOPNOTSUPP
+	}
+
+	srclen := src.NumBytes()
+	if srclen == 0 {
+		return 0, offset, nil
+	}
+
+	rf := fd.inode().impl.(*regularFile)
+	in := &rf.inode
+	in.mu.Lock()
+	defer in.mu.Unlock()
+
+	// If the file is opened with O_APPEND, update offset to file size.
+	if fd.vfsfd.StatusFlags()&linux.O_APPEND != 0 {
+		// Locking in.mu is sufficient for reading the size.
+		offset = int64(in.diskInode.Size())
+	}
+	if end := offset + srclen; end < offset {
+		// Overflow.
+		return 0, offset, syserror.EINVAL
+	}
+
+	srclen, err = vfs.CheckLimit(ctx, offset, srclen)
+	if err != nil {
+		return 0, offset, err
+	}
+	src = src.TakeFirst64(srclen)
+	if uint64(offset)+uint64(srclen) > math.MaxUint32*in.blkSize {
+		return 0, offset, syserror.EFBIG
+	}
+	if err := in.fs.prepareWrite(ctx); err != nil {
+		return 0, offset, err
+	}
+
+	buf := make([]byte, srclen)
+	n, err := src.CopyIn(ctx, buf)
+	if n == 0 {
+		return 0, offset, err
+	}
+	wn, werr := rf.writeAtLocked(ctx, buf[:n], uint64(offset))
+	if werr != nil {
+		err = werr
+	}
+	return int64(wn), offset + int64(wn), err
 }
 
 // Write implements vfs.FileDescriptionImpl.Write.
 func (fd *regularFileFD) Write(ctx context.Context, src usermem.IOSequence, opts vfs.WriteOptions) (int64, error) {
-	n, err := fd.PWrite(ctx, src, fd.off, opts)
 	fd.offMu.Lock()
-	fd.off += n
+	n, off, err := fd.pwrite(ctx, src, fd.off, opts)
+	fd.off = off
 	fd.offMu.Unlock()
 	return n, err
 }
@@ -137,7 +238,10 @@ func (fd *regularFileFD) Seek(ctx context.Context, offset int64, whence int32) (
 	case linux.SEEK_CUR:
 		offset += fd.off
 	case linux.SEEK_END:
-		offset += int64(fd.inode().diskInode.Size())
+		in := fd.inode()
+		in.mu.RLock()
+		offset += int64(in.diskInode.Size())
+		in.mu.RUnlock()
 	default:
 		return 0, syserror.EINVAL
 	}
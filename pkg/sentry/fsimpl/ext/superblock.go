@@ -0,0 +1,167 @@
+// Copyright 2021 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ext
+
+import (
+	"encoding/binary"
+	"fmt"
+
+	"gvisor.dev/gvisor/pkg/context"
+	"gvisor.dev/gvisor/pkg/sentry/fsimpl/ext/disklayout"
+	ktime "gvisor.dev/gvisor/pkg/sentry/kernel/time"
+)
+
+// The superblock is written back from its raw on-disk representation, since
+// the disklayout structs don't cover all of its fields for all revisions.
+// These are the offsets of the fields that are used in the raw superblock.
+const (
+	sbFreeBlocksCountLoOff = 0x0C
+	sbFreeInodesCountOff   = 0x10
+	sbMountTimeOff         = 0x2C
+	sbWriteTimeOff         = 0x30
+	sbMountCountOff        = 0x34
+	sbStateOff             = 0x3A
+	sbFirstInodeOff        = 0x54
+	sbFeatureCompatOff     = 0x5C
+	sbFeatureIncompatOff   = 0x60
+	sbFeatureRoCompatOff   = 0x64
+	sbUUIDOff              = 0x68
+	sbReservedGdtBlocksOff = 0xCE
+	sbFreeBlocksCountHiOff = 0x158
+	sbBackupBgsOff         = 0x24C
+	sbChecksumSeedOff      = 0x270
+	sbWriteTimeHiOff       = 0x274
+	sbMountTimeHiOff       = 0x275
+	sbChecksumOff          = 0x3FC
+	superBlockSize         = 1024
+
+	// bgChecksumOff is the offset of the checksum in group descriptors.
+	bgChecksumOff = 0x1E
+)
+
+const (
+	// writableIncompatFeatures are the incompatible features supported by
+	// writable filesystems.
+	writableIncompatFeatures = disklayout.SbDirentFileType | disklayout.SbExtents | disklayout.SbIs64Bit | disklayout.SbFlexBg | disklayout.SbCsumSeed
+
+	// writableRoCompatFeatures are the read-only compatible features supported
+	// by writable filesystems.
+	writableRoCompatFeatures = disklayout.SbSparse | disklayout.SbLargeFile | disklayout.SbHugeFile | disklayout.SbGdtCsum | disklayout.SbDirNlink | disklayout.SbExtraIsize | disklayout.SbMetadataCsum
+)
+
+// checkWritable returns an error describing why the filesystem whose raw
+// superblock is sbRaw can not be modified, or nil if it can.
+//
+// Only the features needed by ext4 filesystems created by current versions of
+// mke2fs are supported. Notably, files and directories are created with
+// extents, and directories are not indexed. The journal is not used; it must
+// be empty, and remains so.
+func checkWritable(sb disklayout.SuperBlock, sbRaw []byte) error {
+	if sb.Revision() != disklayout.DynamicRev {
+		return fmt.Errorf("revision %d is not supported", sb.Revision())
+	}
+	incompat := binary.LittleEndian.Uint32(sbRaw[sbFeatureIncompatOff:])
+	if incompat&disklayout.SbRecovery != 0 {
+		return fmt.Errorf("the journal needs recovery")
+	}
+	if incompat&disklayout.SbExtents == 0 || incompat&disklayout.SbDirentFileType == 0 {
+		return fmt.Errorf("filesystems without extents or dirent file types are not supported")
+	}
+	if unsupported := incompat &^ writableIncompatFeatures; unsupported != 0 {
+		return fmt.Errorf("incompatible features %#x are not supported", unsupported)
+	}
+	if unsupported := binary.LittleEndian.Uint32(sbRaw[sbFeatureRoCompatOff:]) &^ writableRoCompatFeatures; unsupported != 0 {
+		return fmt.Errorf("read-only compatible features %#x are not supported", unsupported)
+	}
+	wantDescSize := uint16((&disklayout.BlockGroup32Bit{}).SizeBytes())
+	if incompat&disklayout.SbIs64Bit != 0 {
+		wantDescSize = uint16((&disklayout.BlockGroup64Bit{}).SizeBytes())
+	}
+	if sb.BgDescSize() != wantDescSize {
+		return fmt.Errorf("group descriptor size %d is not supported", sb.BgDescSize())
+	}
+	state := binary.LittleEndian.Uint16(sbRaw[sbStateOff:])
+	if state&disklayout.SbValidFS == 0 {
+		return fmt.Errorf("the filesystem was not cleanly unmounted, run e2fsck")
+	}
+	if state&disklayout.SbErrorFS != 0 {
+		return fmt.Errorf("the filesystem has errors, run e2fsck")
+	}
+	return nil
+}
+
+// prepareWrite must be called before the filesystem is modified. The first
+// time, it marks the filesystem as not cleanly unmounted, so that it is checked
+// if the sandbox exits without unmounting it, like Linux does when mounting
+// filesystems read/write.
+func (fs *filesystem) prepareWrite(ctx context.Context) error {
+	fs.allocMu.Lock()
+	defer fs.allocMu.Unlock()
+	if fs.inUse {
+		return nil
+	}
+	state := binary.LittleEndian.Uint16(fs.sbRaw[sbStateOff:])
+	binary.LittleEndian.PutUint16(fs.sbRaw[sbStateOff:], state&^disklayout.SbValidFS)
+	mountCount := binary.LittleEndian.Uint16(fs.sbRaw[sbMountCountOff:])
+	binary.LittleEndian.PutUint16(fs.sbRaw[sbMountCountOff:], mountCount+1)
+	if clock := ktime.RealtimeClockFromContext(ctx); clock != nil {
+		now := clock.Now().Seconds()
+		binary.LittleEndian.PutUint32(fs.sbRaw[sbMountTimeOff:], uint32(now))
+		fs.sbRaw[sbMountTimeHiOff] = uint8(now >> 32)
+	}
+	if err := fs.writeSuperBlockLocked(ctx); err != nil {
+		return err
+	}
+	fs.inUse = true
+	return nil
+}
+
+// writeSuperBlockLocked writes the superblock back with the current free
+// block and inode counts.
+//
+// Preconditions: fs.allocMu must be locked.
+func (fs *filesystem) writeSuperBlockLocked(ctx context.Context) error {
+	binary.LittleEndian.PutUint32(fs.sbRaw[sbFreeBlocksCountLoOff:], uint32(fs.freeBlocksCount))
+	if fs.sb.IncompatibleFeatures().Is64Bit {
+		binary.LittleEndian.PutUint32(fs.sbRaw[sbFreeBlocksCountHiOff:], uint32(fs.freeBlocksCount>>32))
+	}
+	binary.LittleEndian.PutUint32(fs.sbRaw[sbFreeInodesCountOff:], fs.freeInodesCount)
+	// Release may be called without a clock.
+	if clock := ktime.RealtimeClockFromContext(ctx); clock != nil {
+		now := clock.Now().Seconds()
+		binary.LittleEndian.PutUint32(fs.sbRaw[sbWriteTimeOff:], uint32(now))
+		fs.sbRaw[sbWriteTimeHiOff] = uint8(now >> 32)
+	}
+	if fs.metadataCsum {
+		binary.LittleEndian.PutUint32(fs.sbRaw[sbChecksumOff:], superBlockChecksum(fs.sbRaw))
+	}
+	return fs.writeAt(fs.sbRaw, disklayout.SbOffset)
+}
+
+// markClean marks the filesystem as cleanly unmounted if it was modified.
+func (fs *filesystem) markClean(ctx context.Context) error {
+	fs.allocMu.Lock()
+	defer fs.allocMu.Unlock()
+	if !fs.inUse {
+		return nil
+	}
+	state := binary.LittleEndian.Uint16(fs.sbRaw[sbStateOff:])
+	binary.LittleEndian.PutUint16(fs.sbRaw[sbStateOff:], state|disklayout.SbValidFS)
+	if err := fs.writeSuperBlockLocked(ctx); err != nil {
+		return err
+	}
+	fs.inUse = false
+	return nil
+}
@@ -28,12 +28,19 @@ import (
 type symlink struct {
 	inode  inode
 	target string // immutable
+
+	// data holds the target of slow symlinks, whose targets do not fit in the
+	// inode. It is nil for fast symlinks. Immutable.
+	data *regularFile
 }
 
 // newSymlink is the symlink constructor. It reads out the symlink target from
 // the inode (however it might have been stored).
 func newSymlink(args inodeArgs) (*symlink, error) {
-	var link []byte
+	var (
+		link    []byte
+		regFile *regularFile
+	)
 
 	// If the symlink target is lesser than 60 bytes, its stores in inode.Data().
 	// Otherwise either extents or block maps will be used to store the link.
@@ -42,7 +49,8 @@ func newSymlink(args inodeArgs) (*symlink, error) {
 		link = args.diskInode.Data()[:size]
 	} else {
 		// Create a regular file out of this inode and read out the target.
-		regFile, err := newRegularFile(args)
+		var err error
+		regFile, err = newRegularFile(args)
 		if err != nil {
 			return nil, err
 		}
@@ -53,7 +61,7 @@ func newSymlink(args inodeArgs) (*symlink, error) {
 		}
 	}
 
-	file := &symlink{target: string(link)}
+	file := &symlink{target: string(link), data: regFile}
 	file.inode.init(args, file)
 	return file, nil
 }
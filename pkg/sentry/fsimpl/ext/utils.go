@@ -17,6 +17,7 @@ package ext
 import (
 	"io"
 
+	"gvisor.dev/gvisor/pkg/log"
 	"gvisor.dev/gvisor/pkg/marshal"
 	"gvisor.dev/gvisor/pkg/sentry/fsimpl/ext/disklayout"
 	"gvisor.dev/gvisor/pkg/syserror"
@@ -35,6 +36,42 @@ func readFromDisk(dev io.ReaderAt, abOff int64, v marshal.Marshallable) error {
 	return nil
 }
 
+// readBlock reads the block blk from disk.
+func (fs *filesystem) readBlock(blk uint64) ([]byte, error) {
+	buf := make([]byte, fs.sb.BlockSize())
+	if n, _ := fs.dev.ReadAt(buf, int64(blk*fs.sb.BlockSize())); n < len(buf) {
+		return nil, syserror.EIO
+	}
+	return buf, nil
+}
+
+// writeAt writes buf to disk at the absolute offset provided.
+//
+// Preconditions: The filesystem must be writable.
+func (fs *filesystem) writeAt(buf []byte, abOff int64) error {
+	if n, err := fs.devWriter.WriteAt(buf, abOff); n < len(buf) {
+		log.Warningf("ext fs: write of %d bytes at offset %d failed: %v", len(buf), abOff, err)
+		return syserror.EIO
+	}
+	return nil
+}
+
+// writeBlock writes the block blk to disk.
+//
+// Preconditions: The filesystem must be writable.
+func (fs *filesystem) writeBlock(blk uint64, buf []byte) error {
+	return fs.writeAt(buf, int64(blk*fs.sb.BlockSize()))
+}
+
+// readRawSuperBlock reads the raw superblock from the underlying device.
+func readRawSuperBlock(dev io.ReaderAt) ([]byte, error) {
+	buf := make([]byte, superBlockSize)
+	if n, _ := dev.ReadAt(buf, disklayout.SbOffset); n < len(buf) {
+		return nil, syserror.EIO
+	}
+	return buf, nil
+}
+
 // readSuperBlock reads the SuperBlock from block group 0 in the underlying
 // device. There are three versions of the superblock. This function identifies
 // and returns the correct version.
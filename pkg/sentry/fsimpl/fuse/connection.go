@@ -16,6 +16,7 @@ package fuse
 
 import (
 	"sync"
+	"syscall"
 
 	"gvisor.dev/gvisor/pkg/abi/linux"
 	"gvisor.dev/gvisor/pkg/context"
@@ -54,7 +55,7 @@ type connection struct {
 	// attributeVersion is the version of connection's attributes.
 	attributeVersion uint64
 
-	// We target FUSE 7.23.
+	// We target FUSE 7.38.
 	// The following FUSE_INIT flags are currently unsupported by this implementation:
 	// - FUSE_EXPORT_SUPPORT
 	// - FUSE_POSIX_LOCKS: requires POSIX locks
@@ -62,6 +63,7 @@ type connection struct {
 	// - FUSE_AUTO_INVAL_DATA: requires page caching eviction
 	// - FUSE_DO_READDIRPLUS/FUSE_READDIRPLUS_AUTO: requires FUSE_READDIRPLUS implementation
 	// - FUSE_ASYNC_DIO
+	// - FUSE_WRITEBACK_CACHE: requires page caching
 	// - FUSE_PARALLEL_DIROPS (7.25)
 	// - FUSE_HANDLE_KILLPRIV (7.26)
 	// - FUSE_POSIX_ACL: affects defaultPermissions, posixACL, xattr handler (7.26)
@@ -69,7 +71,12 @@ type connection struct {
 	// - FUSE_CACHE_SYMLINKS (7.28)
 	// - FUSE_NO_OPENDIR_SUPPORT (7.29)
 	// - FUSE_EXPLICIT_INVAL_DATA: requires page caching eviction (7.30)
-	// - FUSE_MAP_ALIGNMENT (7.31)
+	// - FUSE_MAP_ALIGNMENT: requires DAX (7.31)
+	// - FUSE_SUBMOUNTS (7.32)
+	// - FUSE_HANDLE_KILLPRIV_V2 (7.33)
+	// - FUSE_SETXATTR_EXT (7.33)
+	// - FUSE_SECURITY_CTX (7.36)
+	// - FUSE_HAS_INODE_DAX: requires DAX (7.36)
 
 	// initialized after receiving FUSE_INIT reply.
 	// Until it's set, suspend sending FUSE requests.
@@ -145,6 +152,10 @@ type connection struct {
 	// Negotiated in FUSE_INIT.
 	maxPages uint16
 
+	// maxPagesLimit, if non-zero, caps maxPages and maxWrite in FUSE_INIT.
+	// Initialized from filesystemOptions.maxPages.
+	maxPagesLimit uint16
+
 	// minor version of the FUSE protocol.
 	// Negotiated and only set in INIT.
 	minor uint32
@@ -174,6 +185,32 @@ type connection struct {
 	// noOpen if FUSE server doesn't support open operation.
 	// This flag only influence performance, not correctness of the program.
 	noOpen bool
+
+	// noInterrupt if FUSE server doesn't support interrupt operation.
+	// Set when the FUSE server replies to a FUSE_INTERRUPT with ENOSYS.
+	noInterrupt bool
+
+	// noLseek if FUSE server doesn't support lseek operation.
+	// SEEK_DATA and SEEK_HOLE then treat files as a single contiguous block
+	// of data.
+	noLseek bool
+
+	// noPoll if FUSE server doesn't support poll operation.
+	// Regular files are then always ready, as in other filesystems.
+	noPoll bool
+
+	// pollHandles maps the kernel handles sent in FUSE_POLL requests to the
+	// files being polled. Files are not saved, and neither are their handles.
+	// Protected by fd.mu.
+	pollHandles map[uint64]*regularFileFD `state:"nosave"`
+
+	// polls maps the IDs of FUSE_POLL requests awaiting a reply to the kernel
+	// handles they were sent for. Protected by fd.mu.
+	polls map[linux.FUSEOpID]uint64 `state:"nosave"`
+
+	// nextPollHandle is the kernel handle of the next file to be polled.
+	// Protected by fd.mu.
+	nextPollHandle uint64
 }
 
 func (conn *connection) saveInitializedChan() bool {
@@ -212,6 +249,7 @@ func newFUSEConnection(_ context.Context, fuseFD *DeviceFD, opts *filesystemOpti
 		asyncCongestionThreshold: fuseDefaultCongestionThreshold,
 		maxRead:                  opts.maxRead,
 		maxPages:                 fuseDefaultMaxPagesPerReq,
+		maxPagesLimit:            opts.maxPages,
 		initializedChan:          make(chan struct{}),
 		connected:                true,
 	}, nil
@@ -264,7 +302,78 @@ func (conn *connection) Call(t *kernel.Task, r *Request) (*Response, error) {
 		return nil, err
 	}
 
-	return fut.resolve(t)
+	res, err := fut.resolve(t)
+	if err == syserror.ErrInterrupted {
+		return conn.interrupt(r, fut)
+	}
+	return res, err
+}
+
+// interrupt is called when the task waiting for the reply to r is interrupted.
+//
+// If the server hasn't read r yet, r is withdrawn and the system call may be
+// restarted. Otherwise, the server is sent a FUSE_INTERRUPT request for r, and
+// EINTR is returned since r may have been partially processed; the server's
+// reply to r is discarded when it arrives.
+func (conn *connection) interrupt(r *Request, fut *futureResponse) (*Response, error) {
+	conn.fd.mu.Lock()
+	defer conn.fd.mu.Unlock()
+
+	// The reply may have been received in the meantime.
+	select {
+	case <-fut.ch:
+		return fut.getResponse(), nil
+	default:
+	}
+
+	if _, ok := conn.fd.completions[r.id]; !ok {
+		// The reply is being written by the server.
+		return nil, syserror.EINTR
+	}
+
+	if !r.sent {
+		conn.fd.queue.Remove(r)
+		delete(conn.fd.completions, r.id)
+		conn.fd.numActiveRequests--
+		select {
+		case conn.fd.fullQueueCh <- struct{}{}:
+		default:
+		}
+		return nil, syserror.ErrInterrupted
+	}
+
+	if !conn.noInterrupt {
+		conn.queueInterruptLocked(r.id)
+	}
+	return nil, syserror.EINTR
+}
+
+// queueInterruptLocked queues a FUSE_INTERRUPT request for the request with
+// the given ID. As in Linux, interrupts are read by the server before any
+// other request, and don't count towards fd.numActiveRequests.
+//
+// Preconditions: conn.fd.mu must be locked.
+func (conn *connection) queueInterruptLocked(unique linux.FUSEOpID) {
+	conn.fd.queue.PushFront(conn.newInterruptRequestLocked(unique))
+	conn.fd.waitQueue.Notify(waiter.EventIn)
+}
+
+// interruptRecvLocked processes the server's reply to a FUSE_INTERRUPT
+// request.
+//
+// Preconditions: conn.fd.mu must be locked.
+func (conn *connection) interruptRecvLocked(hdr *linux.FUSEHeaderOut) {
+	switch syscall.Errno(-hdr.Error) {
+	case syscall.ENOSYS:
+		conn.noInterrupt = true
+	case syscall.EAGAIN:
+		// The server asks for the interrupt to be sent again, unless the
+		// interrupted request has been replied to since.
+		unique := hdr.Unique &^ linux.FUSE_INT_REQ_BIT
+		if _, ok := conn.fd.completions[unique]; ok {
+			conn.queueInterruptLocked(unique)
+		}
+	}
 }
 
 // callFuture makes a request to the server and returns a future response.
@@ -21,6 +21,7 @@ import (
 	"gvisor.dev/gvisor/pkg/abi/linux"
 	"gvisor.dev/gvisor/pkg/context"
 	"gvisor.dev/gvisor/pkg/sentry/kernel/auth"
+	"gvisor.dev/gvisor/pkg/usermem"
 )
 
 // consts used by FUSE_INIT negotiation.
@@ -43,7 +44,14 @@ const (
 
 	// The FUSE_INIT_IN flags sent to the daemon.
 	// TODO(gvisor.dev/issue/3199): complete the flags.
-	fuseDefaultInitFlags = linux.FUSE_MAX_PAGES
+	//
+	// FUSE_SPLICE_READ and FUSE_SPLICE_WRITE only tell the daemon that it
+	// may use splice(2) to read requests from and write replies to the FUSE
+	// device, which is supported like for any other file.
+	fuseDefaultInitFlags = linux.FUSE_MAX_PAGES | linux.FUSE_SPLICE_READ | linux.FUSE_SPLICE_WRITE | linux.FUSE_INIT_EXT
+
+	// The upper 32 bits of the FUSE_INIT_IN flags sent to the daemon.
+	fuseDefaultInitFlags2 = 0
 )
 
 // Adjustable maximums for Connection's cogestion control parameters.
@@ -82,6 +90,7 @@ func (conn *connection) InitSend(creds *auth.Credentials, pid uint32) error {
 		// TODO(gvisor.dev/issue/3196): find appropriate way to calculate this
 		MaxReadahead: fuseDefaultMaxReadahead,
 		Flags:        fuseDefaultInitFlags,
+		Flags2:       fuseDefaultInitFlags2,
 	}
 
 	req := conn.NewRequest(creds, pid, 0, linux.FUSE_INIT, &in)
@@ -154,6 +163,15 @@ func (conn *connection) initProcessReply(out *linux.FUSEInitOut, hasSysAdminCap
 		}
 	}
 
+	if limit := conn.maxPagesLimit; limit != 0 {
+		if conn.maxPages > limit {
+			conn.maxPages = limit
+		}
+		if maxWrite := uint32(limit) << usermem.PageShift; conn.maxWrite > maxWrite {
+			conn.maxWrite = maxWrite
+		}
+	}
+
 	// No support for limits before minor version 13.
 	if out.Minor >= 13 {
 		conn.asyncMu.Lock()
@@ -19,9 +19,11 @@ import (
 	"syscall"
 	"testing"
 
+	"gvisor.dev/gvisor/pkg/abi/linux"
 	"gvisor.dev/gvisor/pkg/sentry/kernel"
 	"gvisor.dev/gvisor/pkg/sentry/kernel/auth"
 	"gvisor.dev/gvisor/pkg/syserror"
+	"gvisor.dev/gvisor/pkg/usermem"
 )
 
 // TestConnectionInitBlock tests if initialization
@@ -109,3 +111,91 @@ func TestConnectionAbort(t *testing.T) {
 	}
 
 }
+
+func TestConnectionInterrupt(t *testing.T) {
+	s := setup(t)
+	defer s.Destroy()
+
+	k := kernel.KernelFromContext(s.Ctx)
+	creds := auth.CredentialsFromContext(s.Ctx)
+	task := kernel.TaskFromContext(s.Ctx)
+
+	conn, _, err := newTestConnection(s, k, maxActiveRequestsDefault)
+	if err != nil {
+		t.Fatalf("newTestConnection: %v", err)
+	}
+
+	testObj := &testPayload{
+		data: rand.Uint32(),
+	}
+
+	// A request that the server hasn't read yet is withdrawn.
+	req := conn.NewRequest(creds, 0, 0, 0, testObj)
+	fut, err := conn.callFutureLocked(task, req)
+	if err != nil {
+		t.Fatalf("callFutureLocked failed: %v", err)
+	}
+	if _, err := conn.interrupt(req, fut); err != syserror.ErrInterrupted {
+		t.Errorf("interrupt() of unsent request got err %v, want %v", err, syserror.ErrInterrupted)
+	}
+	if !conn.fd.queue.Empty() || len(conn.fd.completions) != 0 || conn.fd.numActiveRequests != 0 {
+		t.Errorf("unsent request wasn't withdrawn")
+	}
+
+	// A request that the server has read is interrupted.
+	req = conn.NewRequest(creds, 0, 0, 0, testObj)
+	fut, err = conn.callFutureLocked(task, req)
+	if err != nil {
+		t.Fatalf("callFutureLocked failed: %v", err)
+	}
+	conn.fd.queue.Remove(req)
+	req.sent = true
+	if _, err := conn.interrupt(req, fut); err != syserror.EINTR {
+		t.Errorf("interrupt() of sent request got err %v, want %v", err, syserror.EINTR)
+	}
+	intr := conn.fd.queue.Front()
+	if intr == nil || intr.hdr.Opcode != linux.FUSE_INTERRUPT || intr.hdr.Unique != req.id|linux.FUSE_INT_REQ_BIT {
+		t.Fatalf("FUSE_INTERRUPT request wasn't queued")
+	}
+	conn.fd.queue.Remove(intr)
+
+	// The server doesn't support interrupts.
+	conn.interruptRecvLocked(&linux.FUSEHeaderOut{
+		Error:  -int32(syscall.ENOSYS),
+		Unique: intr.hdr.Unique,
+	})
+	if !conn.noInterrupt {
+		t.Errorf("noInterrupt wasn't set after ENOSYS reply to FUSE_INTERRUPT")
+	}
+}
+
+// TestConnectionMaxPagesLimit tests that the sizes negotiated in FUSE_INIT
+// are capped for transports with fixed-size buffers.
+func TestConnectionMaxPagesLimit(t *testing.T) {
+	s := setup(t)
+	defer s.Destroy()
+
+	k := kernel.KernelFromContext(s.Ctx)
+	conn, _, err := newTestConnection(s, k, maxActiveRequestsDefault)
+	if err != nil {
+		t.Fatalf("newTestConnection: %v", err)
+	}
+	conn.maxPagesLimit = 32
+
+	out := linux.FUSEInitOut{
+		Major:    linux.FUSE_KERNEL_VERSION,
+		Minor:    linux.FUSE_KERNEL_MINOR_VERSION,
+		Flags:    linux.FUSE_MAX_PAGES,
+		MaxWrite: 1 << 20,
+		MaxPages: 256,
+	}
+	if err := conn.initProcessReply(&out, true); err != nil {
+		t.Fatalf("initProcessReply: %v", err)
+	}
+	if conn.maxPages != 32 {
+		t.Errorf("got maxPages %d, want 32", conn.maxPages)
+	}
+	if want := uint32(32 * usermem.PageSize); conn.maxWrite != want {
+		t.Errorf("got maxWrite %d, want %d", conn.maxWrite, want)
+	}
+}
@@ -221,9 +221,11 @@ func (fd *DeviceFD) readLocked(ctx context.Context, dst usermem.IOSequence, opts
 
 	// Fully done with this req, remove it from the queue.
 	fd.queue.Remove(req)
+	req.sent = true
 
 	// Remove noReply ones from map of requests expecting a reply.
-	if req.noReply {
+	// Interrupts were never added to it.
+	if req.noReply && req.hdr.Opcode != linux.FUSE_INTERRUPT {
 		fd.numActiveRequests -= 1
 		delete(fd.completions, req.hdr.Unique)
 	}
@@ -319,6 +321,14 @@ func (fd *DeviceFD) writeLocked(ctx context.Context, src usermem.IOSequence, opt
 			// end of the write, the writeCursor will be set to 0 thereby allowing
 			// the next request to overwrite whats in the buffer,
 
+			if hdr.Unique == 0 || hdr.Unique&linux.FUSE_INT_REQ_BIT != 0 {
+				// Notifications and replies to interrupts don't complete a
+				// request. Collect their payload in a future response that
+				// nobody waits for.
+				fd.writeCursorFR = &futureResponse{hdr: &hdr}
+				continue
+			}
+
 			fut, ok := fd.completions[hdr.Unique]
 			if !ok {
 				// Server sent us a response for a request we never sent,
@@ -338,14 +348,23 @@ func (fd *DeviceFD) writeLocked(ctx context.Context, src usermem.IOSequence, opt
 		}
 	}
 
-	if fd.writeCursorFR != nil {
-		if err := fd.sendResponse(ctx, fd.writeCursorFR); err != nil {
-			return 0, err
-		}
-
+	if fut := fd.writeCursorFR; fut != nil {
 		// Ready the device for the next request.
 		fd.writeCursorFR = nil
 		fd.writeCursor = 0
+
+		var err error
+		switch {
+		case fut.hdr.Unique == 0:
+			err = fd.notifyLocked(ctx, fut.getResponse())
+		case fut.hdr.Unique&linux.FUSE_INT_REQ_BIT != 0:
+			fd.fs.conn.interruptRecvLocked(fut.hdr)
+		default:
+			err = fd.sendResponse(ctx, fut)
+		}
+		if err != nil {
+			return 0, err
+		}
 	}
 
 	return n, nil
@@ -444,7 +463,7 @@ func (fd *DeviceFD) sendError(ctx context.Context, errno int32, unique linux.FUS
 }
 
 // asyncCallBack executes pre-defined callback function for async requests.
-// Currently used by: FUSE_INIT.
+// Currently used by: FUSE_INIT, FUSE_POLL.
 func (fd *DeviceFD) asyncCallBack(ctx context.Context, r *Response) error {
 	switch r.opcode {
 	case linux.FUSE_INIT:
@@ -452,7 +471,29 @@ func (fd *DeviceFD) asyncCallBack(ctx context.Context, r *Response) error {
 		rootUserNs := kernel.KernelFromContext(ctx).RootUserNamespace()
 		return fd.fs.conn.InitRecv(r, creds.HasCapabilityIn(linux.CAP_SYS_ADMIN, rootUserNs))
 		// TODO(gvisor.dev/issue/3247): support async read: correctly process the response.
+	case linux.FUSE_POLL:
+		return fd.fs.conn.pollRecvLocked(r)
 	}
 
 	return nil
 }
+
+// notifyLocked processes a notification sent by the FUSE server. The
+// notification code is sent in the error field of the header.
+//
+// Preconditions: fd.mu must be held.
+func (fd *DeviceFD) notifyLocked(ctx context.Context, r *Response) error {
+	switch r.hdr.Error {
+	case linux.FUSE_NOTIFY_POLL:
+		var out linux.FUSENotifyPollWakeupOut
+		if err := r.UnmarshalPayload(&out); err != nil {
+			return syserror.EINVAL
+		}
+		fd.fs.conn.pollWakeupLocked(out.Kh)
+		return nil
+	default:
+		// TODO(gvisor.dev/issue/3234): support cache invalidation and
+		// retrieval notifications.
+		return syserror.ENOSYS
+	}
+}
@@ -73,6 +73,11 @@ type filesystemOptions struct {
 	// specified as "max_read" in fs parameters.
 	// If not specified by user, use math.MaxUint32 as default value.
 	maxRead uint32
+
+	// maxPages, if non-zero, is the maximum number of pages of data in a
+	// single request or reply. It is set for transports with fixed-size
+	// buffers, such as virtio-fs.
+	maxPages uint16
 }
 
 // filesystem implements vfs.FilesystemImpl.
@@ -91,6 +96,11 @@ type filesystem struct {
 
 	// umounted is true if filesystem.Release() has been called.
 	umounted bool
+
+	// virtio is the transport to the server if the filesystem is a virtiofs
+	// filesystem, rather than one served through /dev/fuse. virtio is
+	// immutable.
+	virtio *virtioFSTransport `state:"nosave"`
 }
 
 // Name implements vfs.FilesystemType.Name.
@@ -220,7 +230,7 @@ func (fsType FilesystemType) GetFilesystem(ctx context.Context, vfsObj *vfs.Virt
 }
 
 // newFUSEFilesystem creates a new FUSE filesystem.
-func newFUSEFilesystem(ctx context.Context, vfsObj *vfs.VirtualFilesystem, fsType *FilesystemType, fuseFD *DeviceFD, devMinor uint32, opts *filesystemOptions) (*filesystem, error) {
+func newFUSEFilesystem(ctx context.Context, vfsObj *vfs.VirtualFilesystem, fsType vfs.FilesystemType, fuseFD *DeviceFD, devMinor uint32, opts *filesystemOptions) (*filesystem, error) {
 	conn, err := newFUSEConnection(ctx, fuseFD, opts)
 	if err != nil {
 		log.Warningf("fuse.NewFUSEFilesystem: NewFUSEConnection failed with error: %v", err)
@@ -256,6 +266,10 @@ func (fs *filesystem) Release(ctx context.Context) {
 
 	fs.conn.fd.mu.Unlock()
 
+	if fs.virtio != nil {
+		fs.virtio.stop()
+	}
+
 	fs.Filesystem.VFSFilesystem().VirtualFilesystem().PutAnonBlockDevMinor(fs.devMinor)
 	fs.Filesystem.Release(ctx)
 }
@@ -336,7 +350,7 @@ func (i *inode) Open(ctx context.Context, rp *vfs.ResolvingPath, d *kernfs.Dentr
 		fd = &(directoryFD.fileDescription)
 		fdImpl = directoryFD
 	} else {
-		regularFD := &regularFileFD{}
+		regularFD := &regularFileFD{creds: auth.CredentialsFromContext(ctx)}
 		fd = &(regularFD.fileDescription)
 		fdImpl = regularFD
 	}
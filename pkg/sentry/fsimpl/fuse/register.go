@@ -16,8 +16,6 @@ package fuse
 
 import (
 	"gvisor.dev/gvisor/pkg/abi/linux"
-	"gvisor.dev/gvisor/pkg/context"
-	"gvisor.dev/gvisor/pkg/sentry/fsimpl/devtmpfs"
 	"gvisor.dev/gvisor/pkg/sentry/vfs"
 )
 
@@ -25,18 +23,11 @@ import (
 func Register(vfsObj *vfs.VirtualFilesystem) error {
 	if err := vfsObj.RegisterDevice(vfs.CharDevice, linux.MISC_MAJOR, fuseDevMinor, fuseDevice{}, &vfs.RegisterDeviceOptions{
 		GroupName: "misc",
+		Pathname:  "fuse",
+		FilePerms: 0666,
 	}); err != nil {
 		return err
 	}
 
 	return nil
 }
-
-// CreateDevtmpfsFile creates a device special file in devtmpfs.
-func CreateDevtmpfsFile(ctx context.Context, dev *devtmpfs.Accessor) error {
-	if err := dev.CreateDeviceFile(ctx, "fuse", vfs.CharDevice, linux.MISC_MAJOR, fuseDevMinor, 0666 /* mode */); err != nil {
-		return err
-	}
-
-	return nil
-}
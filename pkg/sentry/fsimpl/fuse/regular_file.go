@@ -22,9 +22,13 @@ import (
 
 	"gvisor.dev/gvisor/pkg/abi/linux"
 	"gvisor.dev/gvisor/pkg/context"
+	"gvisor.dev/gvisor/pkg/log"
+	"gvisor.dev/gvisor/pkg/sentry/kernel"
+	"gvisor.dev/gvisor/pkg/sentry/kernel/auth"
 	"gvisor.dev/gvisor/pkg/sentry/vfs"
 	"gvisor.dev/gvisor/pkg/syserror"
 	"gvisor.dev/gvisor/pkg/usermem"
+	"gvisor.dev/gvisor/pkg/waiter"
 )
 
 type regularFileFD struct {
@@ -34,6 +38,29 @@ type regularFileFD struct {
 	off int64
 	// offMu protects off.
 	offMu sync.Mutex
+
+	// waitQueue is used to notify waiters of the readiness reported by the
+	// FUSE server.
+	waitQueue waiter.Queue
+
+	// revents is the readiness last reported by the FUSE server in reply to
+	// a FUSE_POLL request. Accessed atomically.
+	revents uint64
+
+	// creds are the credentials of the opener, used for FUSE_POLL requests,
+	// which aren't sent on behalf of any task.
+	creds *auth.Credentials
+
+	// pollHandle is the kernel handle sent in FUSE_POLL requests for this
+	// file, or 0 if it hasn't been polled yet. Protected by the connection's
+	// fd.mu.
+	pollHandle uint64
+}
+
+// Release implements vfs.FileDescriptionImpl.Release.
+func (fd *regularFileFD) Release(ctx context.Context) {
+	fd.inode().fs.conn.releasePoll(fd)
+	fd.fileDescription.Release(ctx)
 }
 
 // PRead implements vfs.FileDescriptionImpl.PRead.
@@ -121,6 +148,7 @@ func (fd *regularFileFD) Read(ctx context.Context, dst usermem.IOSequence, opts
 	n, err := fd.PRead(ctx, dst, fd.off, opts)
 	fd.off += n
 	fd.offMu.Unlock()
+	fd.repoll()
 	return n, err
 }
 
@@ -136,9 +164,231 @@ func (fd *regularFileFD) Write(ctx context.Context, src usermem.IOSequence, opts
 	n, off, err := fd.pwrite(ctx, src, fd.off, opts)
 	fd.off = off
 	fd.offMu.Unlock()
+	fd.repoll()
 	return n, err
 }
 
+// Seek implements vfs.FileDescriptionImpl.Seek.
+func (fd *regularFileFD) Seek(ctx context.Context, offset int64, whence int32) (int64, error) {
+	if fd.Nonseekable {
+		return 0, syserror.ESPIPE
+	}
+
+	fd.offMu.Lock()
+	defer fd.offMu.Unlock()
+
+	inode := fd.inode()
+	conn := inode.fs.conn
+	switch whence {
+	case linux.SEEK_SET:
+		// Use offset as specified.
+	case linux.SEEK_CUR:
+		offset += fd.off
+	case linux.SEEK_END, linux.SEEK_DATA, linux.SEEK_HOLE:
+		if whence != linux.SEEK_END && !conn.noLseek {
+			off, err := fd.lseek(ctx, offset, whence)
+			if err != syserror.ENOSYS {
+				if err != nil {
+					return 0, err
+				}
+				fd.off = off
+				return off, nil
+			}
+			conn.noLseek = true
+		}
+
+		// Ensure file size is up to date.
+		if err := inode.reviseAttr(ctx, linux.FUSE_GETATTR_FH, fd.Fh); err != nil {
+			return 0, err
+		}
+		size := int64(atomic.LoadUint64(&inode.size))
+		// For SEEK_DATA and SEEK_HOLE, treat the file as a single contiguous
+		// block of data.
+		switch whence {
+		case linux.SEEK_END:
+			offset += size
+		case linux.SEEK_DATA:
+			if offset > size {
+				return 0, syserror.ENXIO
+			}
+			// Use offset as specified.
+		case linux.SEEK_HOLE:
+			if offset > size {
+				return 0, syserror.ENXIO
+			}
+			offset = size
+		}
+	default:
+		return 0, syserror.EINVAL
+	}
+	if offset < 0 {
+		return 0, syserror.EINVAL
+	}
+	fd.off = offset
+	return offset, nil
+}
+
+// lseek sends a FUSE_LSEEK request for SEEK_DATA or SEEK_HOLE, and returns
+// the resulting offset.
+func (fd *regularFileFD) lseek(ctx context.Context, offset int64, whence int32) (int64, error) {
+	t := kernel.TaskFromContext(ctx)
+	if t == nil {
+		log.Warningf("fusefs.Seek: couldn't get kernel task from context")
+		return 0, syserror.EINVAL
+	}
+
+	conn := fd.inode().fs.conn
+	in := linux.FUSELseekIn{
+		Fh:     fd.Fh,
+		Offset: uint64(offset),
+		Whence: uint32(whence),
+	}
+	req := conn.NewRequest(auth.CredentialsFromContext(ctx), uint32(t.ThreadID()), fd.inode().nodeID, linux.FUSE_LSEEK, &in)
+	res, err := conn.Call(t, req)
+	if err != nil {
+		return 0, err
+	}
+	if err := res.Error(); err != nil {
+		return 0, err
+	}
+
+	out := linux.FUSELseekOut{}
+	if err := res.UnmarshalPayload(&out); err != nil {
+		return 0, err
+	}
+	return int64(out.Offset), nil
+}
+
+// Readiness implements waiter.Waitable.Readiness.
+//
+// Unlike Linux, which sends a FUSE_POLL request and waits for its reply, the
+// readiness last reported by the FUSE server is returned, since Readiness
+// cannot block. EventRegister sends FUSE_POLL requests, whose replies notify
+// waiters.
+func (fd *regularFileFD) Readiness(mask waiter.EventMask) waiter.EventMask {
+	if fd.inode().fs.conn.noPoll {
+		return fd.fileDescription.Readiness(mask)
+	}
+	return waiter.EventMask(atomic.LoadUint64(&fd.revents)) & mask
+}
+
+// EventRegister implements waiter.Waitable.EventRegister.
+func (fd *regularFileFD) EventRegister(e *waiter.Entry, mask waiter.EventMask) {
+	fd.waitQueue.EventRegister(e, mask)
+	fd.inode().fs.conn.poll(fd)
+}
+
+// EventUnregister implements waiter.Waitable.EventUnregister.
+func (fd *regularFileFD) EventUnregister(e *waiter.Entry) {
+	fd.waitQueue.EventUnregister(e)
+}
+
+// repoll asks the FUSE server for the readiness of fd again after I/O that
+// may have changed it, if there are waiters.
+func (fd *regularFileFD) repoll() {
+	if !fd.waitQueue.IsEmpty() {
+		fd.inode().fs.conn.poll(fd)
+	}
+}
+
+// poll sends a FUSE_POLL request for fd, asking the FUSE server to send a
+// FUSE_NOTIFY_POLL notification when the readiness of fd changes. The reply
+// is processed by pollRecvLocked without blocking the caller.
+func (conn *connection) poll(fd *regularFileFD) {
+	conn.fd.mu.Lock()
+	defer conn.fd.mu.Unlock()
+	conn.pollLocked(fd)
+}
+
+// pollLocked implements poll.
+//
+// Preconditions: conn.fd.mu must be locked.
+func (conn *connection) pollLocked(fd *regularFileFD) {
+	if conn.noPoll || !conn.Initialized() {
+		return
+	}
+
+	if fd.pollHandle == 0 {
+		if conn.pollHandles == nil {
+			conn.pollHandles = make(map[uint64]*regularFileFD)
+		}
+		conn.nextPollHandle++
+		fd.pollHandle = conn.nextPollHandle
+		conn.pollHandles[fd.pollHandle] = fd
+	}
+
+	in := linux.FUSEPollIn{
+		Fh:     fd.Fh,
+		Kh:     fd.pollHandle,
+		Flags:  linux.FUSE_POLL_SCHEDULE_NOTIFY,
+		Events: fd.waitQueue.Events().ToLinux(),
+	}
+	// There may be no task to block on, so the request is queued even if
+	// there are too many active requests.
+	req := conn.newRequestLocked(fd.creds, 0 /* pid */, fd.inode().nodeID, linux.FUSE_POLL, &in)
+	req.async = true
+	if _, err := conn.callFutureLocked(nil, req); err != nil {
+		return
+	}
+	if conn.polls == nil {
+		conn.polls = make(map[linux.FUSEOpID]uint64)
+	}
+	conn.polls[req.id] = fd.pollHandle
+}
+
+// pollRecvLocked processes the reply to a FUSE_POLL request.
+//
+// Preconditions: conn.fd.mu must be locked.
+func (conn *connection) pollRecvLocked(res *Response) error {
+	kh, ok := conn.polls[res.hdr.Unique]
+	if !ok {
+		return nil
+	}
+	delete(conn.polls, res.hdr.Unique)
+
+	var revents waiter.EventMask
+	if err := res.Error(); err == syserror.ENOSYS {
+		// Fall back to regular files being always ready.
+		conn.noPoll = true
+		revents = waiter.EventIn | waiter.EventOut
+	} else if err != nil {
+		revents = waiter.EventErr
+	} else {
+		out := linux.FUSEPollOut{}
+		if err := res.UnmarshalPayload(&out); err != nil {
+			return err
+		}
+		revents = waiter.EventMaskFromLinux(out.Revents)
+	}
+
+	// The file may have been released in the meantime.
+	if fd, ok := conn.pollHandles[kh]; ok {
+		atomic.StoreUint64(&fd.revents, uint64(revents))
+		fd.waitQueue.Notify(revents)
+	}
+	return nil
+}
+
+// pollWakeupLocked processes a FUSE_NOTIFY_POLL notification for the file with
+// the given kernel handle, by asking the FUSE server for its readiness again.
+//
+// Preconditions: conn.fd.mu must be locked.
+func (conn *connection) pollWakeupLocked(kh uint64) {
+	if fd, ok := conn.pollHandles[kh]; ok {
+		conn.pollLocked(fd)
+	}
+}
+
+// releasePoll forgets the kernel handle of fd, which is being released.
+func (conn *connection) releasePoll(fd *regularFileFD) {
+	conn.fd.mu.Lock()
+	defer conn.fd.mu.Unlock()
+	if fd.pollHandle != 0 {
+		delete(conn.pollHandles, fd.pollHandle)
+		fd.pollHandle = 0
+	}
+}
+
 // pwrite returns the number of bytes written, final offset and error. The
 // final offset should be ignored by PWrite.
 func (fd *regularFileFD) pwrite(ctx context.Context, src usermem.IOSequence, offset int64, opts vfs.WriteOptions) (written, finalOff int64, err error) {
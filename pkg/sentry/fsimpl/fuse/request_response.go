@@ -70,6 +70,16 @@ func (r *fuseInitRes) UnmarshalBytes(src []byte) {
 		out.MaxPages = uint16(usermem.ByteOrder.Uint16(src[:2]))
 		src = src[2:]
 	}
+	// Introduced in FUSE kernel version 7.31.
+	if len(src) >= 2 {
+		out.MapAlignment = uint16(usermem.ByteOrder.Uint16(src[:2]))
+		src = src[2:]
+	}
+	// Introduced in FUSE kernel version 7.36.
+	if len(src) >= 4 {
+		out.Flags2 = uint32(usermem.ByteOrder.Uint32(src[:4]))
+		src = src[4:]
+	}
 	_ = src // Remove unused warning.
 }
 
@@ -102,12 +112,22 @@ type Request struct {
 	// If we don't care its response.
 	// Manually set by the caller.
 	noReply bool
+
+	// If this request has been read by the server.
+	sent bool
 }
 
 // NewRequest creates a new request that can be sent to the FUSE server.
 func (conn *connection) NewRequest(creds *auth.Credentials, pid uint32, ino uint64, opcode linux.FUSEOpcode, payload marshal.Marshallable) *Request {
 	conn.fd.mu.Lock()
 	defer conn.fd.mu.Unlock()
+	return conn.newRequestLocked(creds, pid, ino, opcode, payload)
+}
+
+// newRequestLocked creates a new request that can be sent to the FUSE server.
+//
+// Preconditions: conn.fd.mu must be locked.
+func (conn *connection) newRequestLocked(creds *auth.Credentials, pid uint32, ino uint64, opcode linux.FUSEOpcode, payload marshal.Marshallable) *Request {
 	conn.fd.nextOpID += linux.FUSEOpID(reqIDStep)
 
 	hdrLen := (*linux.FUSEHeaderIn)(nil).SizeBytes()
@@ -134,6 +154,32 @@ func (conn *connection) NewRequest(creds *auth.Credentials, pid uint32, ino uint
 	}
 }
 
+// newInterruptRequestLocked creates a FUSE_INTERRUPT request for the request
+// with the given ID. The FUSE server only replies to it to report that the
+// interrupt is unsupported, or should be sent again.
+//
+// Preconditions: conn.fd.mu must be locked.
+func (conn *connection) newInterruptRequestLocked(unique linux.FUSEOpID) *Request {
+	in := linux.FUSEInterruptIn{Unique: unique}
+	hdrLen := (*linux.FUSEHeaderIn)(nil).SizeBytes()
+	hdr := linux.FUSEHeaderIn{
+		Len:    uint32(hdrLen + in.SizeBytes()),
+		Opcode: linux.FUSE_INTERRUPT,
+		Unique: unique | linux.FUSE_INT_REQ_BIT,
+	}
+
+	buf := make([]byte, hdr.Len)
+	hdr.MarshalBytes(buf[:hdrLen])
+	in.MarshalBytes(buf[hdrLen:])
+
+	return &Request{
+		id:      hdr.Unique,
+		hdr:     &hdr,
+		data:    buf,
+		noReply: true,
+	}
+}
+
 // futureResponse represents an in-flight request, that may or may not have
 // completed yet. Convert it to a resolved Response by calling Resolve, but note
 // that this may block.
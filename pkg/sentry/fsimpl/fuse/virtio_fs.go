@@ -0,0 +1,440 @@
+// Copyright 2021 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fuse
+
+import (
+	"fmt"
+	"strconv"
+	"syscall"
+
+	"gvisor.dev/gvisor/pkg/abi/linux"
+	"gvisor.dev/gvisor/pkg/context"
+	"gvisor.dev/gvisor/pkg/log"
+	"gvisor.dev/gvisor/pkg/sentry/kernel"
+	"gvisor.dev/gvisor/pkg/sentry/kernel/auth"
+	"gvisor.dev/gvisor/pkg/sentry/vfs"
+	"gvisor.dev/gvisor/pkg/sync"
+	"gvisor.dev/gvisor/pkg/syserror"
+	"gvisor.dev/gvisor/pkg/unet"
+	"gvisor.dev/gvisor/pkg/usermem"
+	"gvisor.dev/gvisor/pkg/vhostuser"
+	"gvisor.dev/gvisor/pkg/waiter"
+)
+
+// VirtioFSName is the name of the virtiofs filesystem type.
+const VirtioFSName = "virtiofs"
+
+// A virtiofs filesystem is a FUSE filesystem served by a virtio-fs device
+// backend, such as virtiofsd, over vhost-user, rather than by a FUSE server
+// reading and writing /dev/fuse. Compare Linux's fs/fuse/virtio_fs.c.
+//
+// FUSE requests are read from the filesystem's internal DeviceFD into
+// buffers in memory shared with the backend, and are made available to the
+// backend on the request queue. The backend writes replies to buffers that
+// accompany the requests, which are then written to the DeviceFD as if they
+// had been written by a FUSE server.
+const (
+	// virtioFSHiprioQueue is the index of the queue for FUSE_FORGET and
+	// FUSE_INTERRUPT requests. It is set up since backends expect it, but
+	// unused: neither request is sent.
+	virtioFSHiprioQueue = 0
+
+	// virtioFSRequestQueue is the index of the queue for all other requests.
+	virtioFSRequestQueue = 1
+
+	// virtioFSSlots is the number of requests that can be in flight on the
+	// request queue.
+	virtioFSSlots = 16
+
+	// virtioFSQueueSize is the number of descriptors in the request queue.
+	// Each request uses two: one for the request and one for the reply.
+	virtioFSQueueSize = 2 * virtioFSSlots
+
+	// virtioFSHiprioQueueSize is the number of descriptors in the hiprio
+	// queue.
+	virtioFSHiprioQueueSize = 8
+
+	// virtioFSMaxPages is the maximum number of pages of data in a request
+	// or reply. Each request and reply buffer has room for one more page of
+	// headers.
+	virtioFSMaxPages = 32
+	virtioFSBufSize  = (virtioFSMaxPages + 1) * usermem.PageSize
+)
+
+// VirtioFSFilesystemType implements vfs.FilesystemType for virtiofs.
+//
+// +stateify savable
+type VirtioFSFilesystemType struct{}
+
+// Name implements vfs.FilesystemType.Name.
+func (VirtioFSFilesystemType) Name() string {
+	return VirtioFSName
+}
+
+// Release implements vfs.FilesystemType.Release.
+func (VirtioFSFilesystemType) Release(ctx context.Context) {}
+
+// GetFilesystem implements vfs.FilesystemType.GetFilesystem.
+//
+// The mount data must specify the host FD of a connected vhost-user socket as
+// "fd=N". The filesystem takes ownership of the FD.
+func (fsType VirtioFSFilesystemType) GetFilesystem(ctx context.Context, vfsObj *vfs.VirtualFilesystem, creds *auth.Credentials, source string, opts vfs.GetFilesystemOptions) (*vfs.Filesystem, *vfs.Dentry, error) {
+	mopts := vfs.GenericParseMountOptions(opts.Data)
+	hostFDStr, ok := mopts["fd"]
+	if !ok {
+		log.Warningf("%s.GetFilesystem: host FD of a vhost-user socket must be specified as 'fd=N'", fsType.Name())
+		return nil, nil, syserror.EINVAL
+	}
+	delete(mopts, "fd")
+	hostFD, err := strconv.Atoi(hostFDStr)
+	if err != nil {
+		log.Warningf("%s.GetFilesystem: invalid host FD: fd=%s", fsType.Name(), hostFDStr)
+		return nil, nil, syserror.EINVAL
+	}
+	if len(mopts) != 0 {
+		log.Warningf("%s.GetFilesystem: unsupported or unknown options: %v", fsType.Name(), mopts)
+		return nil, nil, syserror.EINVAL
+	}
+
+	k := kernel.KernelFromContext(ctx)
+	if k == nil {
+		log.Warningf("%s.GetFilesystem: couldn't get kernel from context", fsType.Name())
+		return nil, nil, syserror.EINVAL
+	}
+
+	sock, err := unet.NewSocket(hostFD)
+	if err != nil {
+		return nil, nil, err
+	}
+	t, err := newVirtioFSTransport(sock)
+	if err != nil {
+		log.Warningf("%s.GetFilesystem: failed to set up vhost-user device: %v", fsType.Name(), err)
+		return nil, nil, syserror.EIO
+	}
+
+	devMinor, err := vfsObj.GetAnonBlockDevMinor()
+	if err != nil {
+		t.stop()
+		return nil, nil, err
+	}
+	fsopts := filesystemOptions{
+		rootMode:          0755,
+		maxActiveRequests: maxActiveRequestsDefault,
+		maxRead:           virtioFSMaxPages << usermem.PageShift,
+		maxPages:          virtioFSMaxPages,
+	}
+	fuseFD := &DeviceFD{}
+	fs, err := newFUSEFilesystem(ctx, vfsObj, &fsType, fuseFD, devMinor, &fsopts)
+	if err != nil {
+		vfsObj.PutAnonBlockDevMinor(devMinor)
+		t.stop()
+		return nil, nil, err
+	}
+	// Unlike the DeviceFD of /dev/fuse, fuseFD is never released, so it
+	// doesn't hold a reference on fs; the transport is stopped by
+	// fs.Release() instead.
+	fs.VFSFilesystem().DecRef(ctx)
+	fs.virtio = t
+	// As in Linux, FUSE_INTERRUPT is not supported.
+	fs.conn.noInterrupt = true
+	t.start(k.SupervisorContext(), fuseFD)
+
+	if err := fs.conn.InitSend(creds, 0 /* pid */); err != nil {
+		log.Warningf("%s.InitSend: failed with error: %v", fsType.Name(), err)
+		fs.VFSFilesystem().DecRef(ctx) // returned by newFUSEFilesystem
+		return nil, nil, err
+	}
+
+	root := fs.newRoot(ctx, creds, fsopts.rootMode)
+	return fs.VFSFilesystem(), root.VFSDentry(), nil
+}
+
+// virtioFSSlot is a pair of request and reply buffers in shared memory.
+type virtioFSSlot struct {
+	req   vhostuser.Buffer
+	reply vhostuser.Buffer
+}
+
+// virtioFSTransport moves FUSE requests and replies between a DeviceFD and a
+// virtio-fs device backend.
+type virtioFSTransport struct {
+	// The following fields are immutable.
+	frontend     *vhostuser.Frontend
+	mem          *vhostuser.Memory
+	queue        *vhostuser.Queue
+	kickFD       int
+	hiprioKickFD int
+	callFD       int
+
+	// ctx and fd are set by start, and are immutable thereafter.
+	ctx context.Context
+	fd  *DeviceFD
+
+	// freeSlots holds the slots that are not in flight.
+	freeSlots chan *virtioFSSlot
+
+	// mu protects inflight.
+	mu sync.Mutex
+
+	// inflight maps the head descriptors of the chains in the request queue
+	// to their slots.
+	inflight map[uint16]*virtioFSSlot
+
+	// stopCh is closed to stop the transport's goroutines.
+	stopCh chan struct{}
+
+	// wg is used to wait for the transport's goroutines to exit.
+	wg sync.WaitGroup
+}
+
+// newVirtioFSTransport sets up the device connected to sock. It takes
+// ownership of sock.
+func newVirtioFSTransport(sock *unet.Socket) (*virtioFSTransport, error) {
+	frontend, err := vhostuser.NewFrontend(sock, 0)
+	if err != nil {
+		sock.Close()
+		return nil, err
+	}
+	t := &virtioFSTransport{
+		frontend:     frontend,
+		kickFD:       -1,
+		hiprioKickFD: -1,
+		callFD:       -1,
+		freeSlots:    make(chan *virtioFSSlot, virtioFSSlots),
+		inflight:     make(map[uint16]*virtioFSSlot),
+		stopCh:       make(chan struct{}),
+	}
+	if err := t.init(); err != nil {
+		t.stop()
+		return nil, err
+	}
+	return t, nil
+}
+
+// init allocates shared memory, queues and eventfds, and shares them with
+// the device.
+func (t *virtioFSTransport) init() error {
+	// Memory layout: the request queue, the hiprio queue, then the slots.
+	hiprioOff := vhostuser.QueueBytes(virtioFSQueueSize)
+	slotsOff := hiprioOff + vhostuser.QueueBytes(virtioFSHiprioQueueSize)
+	size := slotsOff + 2*virtioFSSlots*virtioFSBufSize
+	mem, err := vhostuser.NewMemory("virtiofs", int(size))
+	if err != nil {
+		return err
+	}
+	t.mem = mem
+	if t.queue, err = vhostuser.NewQueue(mem, 0, virtioFSQueueSize); err != nil {
+		return err
+	}
+	hiprio, err := vhostuser.NewQueue(mem, hiprioOff, virtioFSHiprioQueueSize)
+	if err != nil {
+		return err
+	}
+	for i := uint64(0); i < virtioFSSlots; i++ {
+		off := slotsOff + 2*i*virtioFSBufSize
+		t.freeSlots <- &virtioFSSlot{
+			req:   vhostuser.Buffer{Addr: off, Len: virtioFSBufSize},
+			reply: vhostuser.Buffer{Addr: off + virtioFSBufSize, Len: virtioFSBufSize, Write: true},
+		}
+	}
+
+	for _, fd := range []*int{&t.kickFD, &t.hiprioKickFD, &t.callFD} {
+		if *fd, err = newEventFD(); err != nil {
+			return err
+		}
+	}
+
+	if err := t.frontend.SetMemTable(mem); err != nil {
+		return err
+	}
+	if err := t.frontend.SetupQueue(virtioFSHiprioQueue, hiprio, t.hiprioKickFD, t.callFD); err != nil {
+		return err
+	}
+	return t.frontend.SetupQueue(virtioFSRequestQueue, t.queue, t.kickFD, t.callFD)
+}
+
+// start starts moving requests and replies between fd and the device. ctx is
+// used to read from and write to fd.
+func (t *virtioFSTransport) start(ctx context.Context, fd *DeviceFD) {
+	t.ctx = ctx
+	t.fd = fd
+	t.wg.Add(2)
+	go t.sendLoop() // S/R-SAFE: virtiofs filesystems can't be saved.
+	go t.recvLoop() // S/R-SAFE: virtiofs filesystems can't be saved.
+}
+
+// stop stops the transport and releases its resources.
+func (t *virtioFSTransport) stop() {
+	close(t.stopCh)
+	if t.callFD >= 0 {
+		// Wake up recvLoop.
+		writeEventFD(t.callFD)
+	}
+	t.wg.Wait()
+
+	// The device stops using the queues when the connection is closed.
+	t.frontend.Close()
+	for _, fd := range []int{t.kickFD, t.hiprioKickFD, t.callFD} {
+		if fd >= 0 {
+			syscall.Close(fd)
+		}
+	}
+	if t.mem != nil {
+		t.mem.Release()
+	}
+}
+
+// sendLoop makes requests read from t.fd available to the device.
+func (t *virtioFSTransport) sendLoop() {
+	defer t.wg.Done()
+	e, ch := waiter.NewChannelEntry(nil)
+	t.fd.waitQueue.EventRegister(&e, waiter.EventIn)
+	defer t.fd.waitQueue.EventUnregister(&e)
+
+	for {
+		var slot *virtioFSSlot
+		select {
+		case slot = <-t.freeSlots:
+		case <-t.stopCh:
+			return
+		}
+
+		n, err := t.readRequest(slot)
+		for err == syserror.ErrWouldBlock {
+			select {
+			case <-ch:
+			case <-t.stopCh:
+				return
+			}
+			n, err = t.readRequest(slot)
+		}
+		if err != nil {
+			log.Warningf("fuse.virtioFSTransport.sendLoop: failed to read request: %v", err)
+			return
+		}
+
+		t.mu.Lock()
+		head, err := t.queue.Add(vhostuser.Buffer{Addr: slot.req.Addr, Len: uint32(n)}, slot.reply)
+		if err == nil {
+			t.inflight[head] = slot
+		}
+		t.mu.Unlock()
+		if err != nil {
+			// Slots never use more than the queue's descriptors.
+			panic(fmt.Sprintf("failed to add virtio-fs request to queue: %v", err))
+		}
+		if err := writeEventFD(t.kickFD); err != nil {
+			log.Warningf("fuse.virtioFSTransport.sendLoop: failed to notify device: %v", err)
+			return
+		}
+	}
+}
+
+// readRequest reads the next request from t.fd into slot's request buffer.
+func (t *virtioFSTransport) readRequest(slot *virtioFSSlot) (int64, error) {
+	dst := usermem.BytesIOSequence(t.mem.Slice(slot.req.Addr, slot.req.Len))
+	t.fd.mu.Lock()
+	defer t.fd.mu.Unlock()
+	return t.fd.readLocked(t.ctx, dst, vfs.ReadOptions{})
+}
+
+// recvLoop writes replies from the device to t.fd.
+func (t *virtioFSTransport) recvLoop() {
+	defer t.wg.Done()
+	for {
+		if err := readEventFD(t.callFD); err != nil {
+			log.Warningf("fuse.virtioFSTransport.recvLoop: failed to wait for device: %v", err)
+			return
+		}
+		select {
+		case <-t.stopCh:
+			return
+		default:
+		}
+
+		for {
+			head, written, ok := t.queue.PopUsed()
+			if !ok {
+				break
+			}
+			t.mu.Lock()
+			slot, ok := t.inflight[head]
+			delete(t.inflight, head)
+			t.mu.Unlock()
+			if !ok {
+				log.Warningf("fuse.virtioFSTransport.recvLoop: device used unknown descriptor %d", head)
+				continue
+			}
+			if written > slot.reply.Len {
+				written = slot.reply.Len
+			}
+			t.writeReply(t.mem.Slice(slot.reply.Addr, written))
+			t.freeSlots <- slot
+		}
+	}
+}
+
+// writeReply writes a reply from the device to t.fd.
+func (t *virtioFSTransport) writeReply(buf []byte) {
+	var hdr linux.FUSEHeaderOut
+	if len(buf) < hdr.SizeBytes() {
+		log.Warningf("fuse.virtioFSTransport.writeReply: device wrote %d bytes, less than a reply header", len(buf))
+		return
+	}
+	hdr.UnmarshalBytes(buf)
+
+	t.fd.mu.Lock()
+	defer t.fd.mu.Unlock()
+	if hdr.Len < uint32(hdr.SizeBytes()) || int(hdr.Len) > len(buf) {
+		log.Warningf("fuse.virtioFSTransport.writeReply: reply of length %d in %d bytes written by device", hdr.Len, len(buf))
+		t.fd.sendError(t.ctx, -int32(syscall.EIO), hdr.Unique)
+		return
+	}
+	if _, err := t.fd.writeLocked(t.ctx, usermem.BytesIOSequence(buf[:hdr.Len]), vfs.WriteOptions{}); err != nil {
+		log.Warningf("fuse.virtioFSTransport.writeReply: failed to write reply: %v", err)
+	}
+}
+
+// newEventFD returns a new blocking eventfd.
+func newEventFD() (int, error) {
+	fd, _, e := syscall.RawSyscall(syscall.SYS_EVENTFD2, 0, 0, 0)
+	if e != 0 {
+		return -1, fmt.Errorf("failed to create eventfd: %v", e)
+	}
+	return int(fd), nil
+}
+
+// readEventFD blocks until the eventfd's counter is non-zero, and resets it.
+func readEventFD(fd int) error {
+	var buf [8]byte
+	for {
+		_, err := syscall.Read(fd, buf[:])
+		if err != syscall.EINTR {
+			return err
+		}
+	}
+}
+
+// writeEventFD increments the eventfd's counter.
+func writeEventFD(fd int) error {
+	var buf [8]byte
+	usermem.ByteOrder.PutUint64(buf[:], 1)
+	for {
+		_, err := syscall.Write(fd, buf[:])
+		if err != syscall.EINTR {
+			return err
+		}
+	}
+}
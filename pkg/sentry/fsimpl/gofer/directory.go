@@ -55,10 +55,11 @@ func (d *dentry) cacheNewChildLocked(child *dentry, name string) {
 // * d.isDir().
 func (d *dentry) cacheNegativeLookupLocked(name string) {
 	// Don't cache negative lookups if InteropModeShared is in effect (since
-	// this makes remote lookup unavoidable), or if d.isSynthetic() (in which
-	// case the only files in the directory are those for which a dentry exists
-	// in d.children). Instead, just delete any previously-cached dentry.
-	if d.fs.opts.interop == InteropModeShared || d.isSynthetic() {
+	// this makes remote lookup unavoidable) and the server doesn't report
+	// invalidations, or if d.isSynthetic() (in which case the only files in
+	// the directory are those for which a dentry exists in d.children).
+	// Instead, just delete any previously-cached dentry.
+	if (d.fs.opts.interop == InteropModeShared && !d.fs.invalidatingMetadata()) || d.isSynthetic() {
 		delete(d.children, name)
 		return
 	}
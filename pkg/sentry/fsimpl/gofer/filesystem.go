@@ -15,6 +15,7 @@
 package gofer
 
 import (
+	"fmt"
 	"math"
 	"sync"
 	"sync/atomic"
@@ -267,6 +268,11 @@ func (fs *filesystem) getChildLocked(ctx context.Context, vfsObj *vfs.VirtualFil
 		// assumed to be correct.
 		return child, nil
 	}
+	if ok && fs.invalidatingMetadata() && (child == nil || child.metadataValid()) {
+		// The server hasn't reported changes to the file at this path since
+		// it was looked up.
+		return child, nil
+	}
 	// We either don't have cached information or need to verify that it's
 	// still correct, either of which requires a remote lookup. Check if this
 	// name is valid before performing the lookup.
@@ -276,11 +282,17 @@ func (fs *filesystem) getChildLocked(ctx context.Context, vfsObj *vfs.VirtualFil
 // Preconditions: Same as getChildLocked, plus:
 // * !parent.isSynthetic().
 func (fs *filesystem) revalidateChildLocked(ctx context.Context, vfsObj *vfs.VirtualFilesystem, parent *dentry, name string, child *dentry, ds **[]*dentry) (*dentry, error) {
+	// Invalidations of the file at name are applied with parent.dirMu locked,
+	// so only invalidations of all metadata can race with the lookup of a new
+	// child.
+	newGen := atomic.LoadUint64(&fs.invalidationEpoch) + 1
+	var gen uint64
 	if child != nil {
 		// Need to lock child.metadataMu because we might be updating child
 		// metadata. We need to hold the lock *before* getting metadata from the
 		// server and release it after updating local metadata.
 		child.metadataMu.Lock()
+		gen = child.metadataGeneration()
 	}
 	qid, file, attrMask, attr, err := parent.file.walkGetAttrOne(ctx, name)
 	if err != nil && err != syserror.ENOENT {
@@ -294,6 +306,7 @@ func (fs *filesystem) revalidateChildLocked(ctx context.Context, vfsObj *vfs.Vir
 			// The file at this path hasn't changed. Just update cached metadata.
 			file.close(ctx)
 			child.updateFromP9AttrsLocked(attrMask, &attr)
+			child.setMetadataValid(gen)
 			child.metadataMu.Unlock()
 			return child, nil
 		}
@@ -319,6 +332,10 @@ func (fs *filesystem) revalidateChildLocked(ctx context.Context, vfsObj *vfs.Vir
 			parent.syntheticChildren--
 			child.decRefNoCaching()
 			parent.dirents = nil
+		} else if child.hostListener != nil {
+			// The socket file was removed or replaced outside the
+			// sandbox, so host processes can no longer connect to it.
+			child.stopHostListener()
 		}
 		*ds = appendDentry(*ds, child)
 	}
@@ -335,6 +352,7 @@ func (fs *filesystem) revalidateChildLocked(ctx context.Context, vfsObj *vfs.Vir
 		delete(parent.children, name)
 		return nil, err
 	}
+	child.setMetadataValid(newGen)
 	parent.cacheNewChildLocked(child, name)
 	// For now, child has 0 references, so our caller should call
 	// child.checkCachingLocked().
@@ -467,6 +485,7 @@ func (fs *filesystem) doCreateAt(ctx context.Context, rp *vfs.ResolvingPath, dir
 		if dir {
 			ev |= linux.IN_ISDIR
 		}
+		parent.noteLocalEvent()
 		parent.watches.Notify(ctx, name, uint32(ev), 0, vfs.InodeEvent, false /* unlinked */)
 		return nil
 	}
@@ -476,18 +495,21 @@ func (fs *filesystem) doCreateAt(ctx context.Context, rp *vfs.ResolvingPath, dir
 	if err := createInRemoteDir(parent, name, &ds); err != nil {
 		return err
 	}
+	if child, ok := parent.children[name]; ok && child == nil {
+		// Delete the now-stale negative dentry.
+		delete(parent.children, name)
+	}
 	if fs.opts.interop != InteropModeShared {
-		if child, ok := parent.children[name]; ok && child == nil {
-			// Delete the now-stale negative dentry.
-			delete(parent.children, name)
-		}
 		parent.touchCMtime()
 		parent.dirents = nil
+	} else {
+		parent.invalidateMetadata()
 	}
 	ev := linux.IN_CREATE
 	if dir {
 		ev |= linux.IN_ISDIR
 	}
+	parent.noteLocalEvent()
 	parent.watches.Notify(ctx, name, uint32(ev), 0, vfs.InodeEvent, false /* unlinked */)
 	return nil
 }
@@ -661,6 +683,7 @@ func (fs *filesystem) unlinkAt(ctx context.Context, rp *vfs.ResolvingPath, dir b
 	}
 
 	// Generate inotify events for rmdir or unlink.
+	parent.noteLocalEvent()
 	if dir {
 		parent.watches.Notify(ctx, name, linux.IN_DELETE|linux.IN_ISDIR, 0, vfs.InodeEvent, true /* unlinked */)
 	} else {
@@ -677,6 +700,8 @@ func (fs *filesystem) unlinkAt(ctx context.Context, rp *vfs.ResolvingPath, dir b
 		if child.isSynthetic() {
 			parent.syntheticChildren--
 			child.decRefNoCaching()
+		} else if child.hostListener != nil {
+			child.stopHostListener()
 		}
 		ds = appendDentry(ds, child)
 	}
@@ -687,6 +712,12 @@ func (fs *filesystem) unlinkAt(ctx context.Context, rp *vfs.ResolvingPath, dir b
 		if dir {
 			parent.decLinks()
 		}
+	} else {
+		parent.invalidateMetadata()
+		if child != nil {
+			// Other links to the file remain.
+			child.invalidateMetadata()
+		}
 	}
 	return nil
 }
@@ -761,7 +792,7 @@ func (fs *filesystem) LinkAt(ctx context.Context, rp *vfs.ResolvingPath, vd vfs.
 		if err := vfs.MayLink(rp.Credentials(), mode, uid, gid); err != nil {
 			return err
 		}
-		if d.nlink == 0 {
+		if d.nlink == 0 && atomic.LoadUint32(&d.linkable) == 0 {
 			return syserror.ENOENT
 		}
 		if d.nlink == math.MaxUint32 {
@@ -772,7 +803,9 @@ func (fs *filesystem) LinkAt(ctx context.Context, rp *vfs.ResolvingPath, vd vfs.
 		}
 
 		// Success!
+		atomic.StoreUint32(&d.linkable, 0)
 		atomic.AddUint32(&d.nlink, 1)
+		d.invalidateMetadata()
 		return nil
 	}, nil)
 }
@@ -781,7 +814,11 @@ func (fs *filesystem) LinkAt(ctx context.Context, rp *vfs.ResolvingPath, vd vfs.
 func (fs *filesystem) MkdirAt(ctx context.Context, rp *vfs.ResolvingPath, opts vfs.MkdirOptions) error {
 	creds := rp.Credentials()
 	return fs.doCreateAt(ctx, rp, true /* dir */, func(parent *dentry, name string, _ **[]*dentry) error {
-		if _, err := parent.file.mkdir(ctx, name, (p9.FileMode)(opts.Mode), (p9.UID)(creds.EffectiveKUID), (p9.GID)(creds.EffectiveKGID)); err != nil {
+		uid, gid, err := fs.p9Owner(creds)
+		if err != nil {
+			return err
+		}
+		if _, err := parent.file.mkdir(ctx, name, (p9.FileMode)(opts.Mode), uid, gid); err != nil {
 			if !opts.ForSyntheticMountpoint || err == syserror.EEXIST {
 				return err
 			}
@@ -817,7 +854,21 @@ func (fs *filesystem) MkdirAt(ctx context.Context, rp *vfs.ResolvingPath, opts v
 func (fs *filesystem) MknodAt(ctx context.Context, rp *vfs.ResolvingPath, opts vfs.MknodOptions) error {
 	return fs.doCreateAt(ctx, rp, false /* dir */, func(parent *dentry, name string, ds **[]*dentry) error {
 		creds := rp.Credentials()
-		_, err := parent.file.mknod(ctx, name, (p9.FileMode)(opts.Mode), opts.DevMajor, opts.DevMinor, (p9.UID)(creds.EffectiveKUID), (p9.GID)(creds.EffectiveKGID))
+		uid, gid, err := fs.p9Owner(creds)
+		if err != nil {
+			return err
+		}
+		if opts.Mode.FileType() == linux.S_IFSOCK && fs.opts.hostUDSCreate {
+			if ep, ok := opts.Endpoint.(transport.ExternalAcceptor); ok {
+				err := parent.bindHostSocketLocked(ctx, name, ep, opts.Endpoint, (p9.FileMode)(opts.Mode), uid, gid, ds)
+				if err != syserror.EPERM && err != syserror.ENOSYS {
+					return err
+				}
+				// Otherwise, the server won't create host sockets; fall
+				// back to mknod.
+			}
+		}
+		_, err = parent.file.mknod(ctx, name, (p9.FileMode)(opts.Mode), opts.DevMajor, opts.DevMinor, uid, gid)
 		if err != syserror.EPERM {
 			return err
 		}
@@ -863,12 +914,8 @@ func (fs *filesystem) MknodAt(ctx context.Context, rp *vfs.ResolvingPath, opts v
 
 // OpenAt implements vfs.FilesystemImpl.OpenAt.
 func (fs *filesystem) OpenAt(ctx context.Context, rp *vfs.ResolvingPath, opts vfs.OpenOptions) (*vfs.FileDescription, error) {
-	// Reject O_TMPFILE, which is not supported; supporting it correctly in the
-	// presence of other remote filesystem users requires remote filesystem
-	// support, and it isn't clear that there's any way to implement this in
-	// 9P.
 	if opts.Flags&linux.O_TMPFILE != 0 {
-		return nil, syserror.EOPNOTSUPP
+		return fs.openTmpfile(ctx, rp, &opts)
 	}
 	mayCreate := opts.Flags&linux.O_CREAT != 0
 	mustCreate := opts.Flags&(linux.O_CREAT|linux.O_EXCL) == (linux.O_CREAT | linux.O_EXCL)
@@ -1048,6 +1095,8 @@ func (d *dentry) open(ctx context.Context, rp *vfs.ResolvingPath, opts *vfs.Open
 
 		if d.cachedMetadataAuthoritative() {
 			d.touchCMtimeLocked()
+		} else {
+			d.invalidateMetadata()
 		}
 	}
 	return vfd, err
@@ -1132,6 +1181,12 @@ func (d *dentry) createAndOpenChildLocked(ctx context.Context, rp *vfs.Resolving
 	}
 	defer mnt.EndWrite()
 
+	creds := rp.Credentials()
+	uid, gid, err := d.fs.p9Owner(creds)
+	if err != nil {
+		return nil, err
+	}
+
 	// 9P2000.L's lcreate takes a fid representing the parent directory, and
 	// converts it into an open fid representing the created file, so we need
 	// to duplicate the directory fid first.
@@ -1139,11 +1194,10 @@ func (d *dentry) createAndOpenChildLocked(ctx context.Context, rp *vfs.Resolving
 	if err != nil {
 		return nil, err
 	}
-	creds := rp.Credentials()
 	name := rp.Component()
 	// We only want the access mode for creating the file.
 	createFlags := p9.OpenFlags(opts.Flags) & p9.OpenFlagsModeMask
-	fdobj, openFile, createQID, _, err := dirfile.create(ctx, name, createFlags, (p9.FileMode)(opts.Mode), (p9.UID)(creds.EffectiveKUID), (p9.GID)(creds.EffectiveKGID))
+	fdobj, openFile, createQID, _, err := dirfile.create(ctx, name, createFlags, (p9.FileMode)(opts.Mode), uid, gid)
 	if err != nil {
 		dirfile.close(ctx)
 		return nil, err
@@ -1197,6 +1251,8 @@ func (d *dentry) createAndOpenChildLocked(ctx context.Context, rp *vfs.Resolving
 	if d.cachedMetadataAuthoritative() {
 		d.touchCMtime()
 		d.dirents = nil
+	} else {
+		d.invalidateMetadata()
 	}
 
 	// Finally, construct a file description representing the created file.
@@ -1222,10 +1278,123 @@ func (d *dentry) createAndOpenChildLocked(ctx context.Context, rp *vfs.Resolving
 		}
 		childVFSFD = &fd.vfsfd
 	}
+	d.noteLocalEvent()
 	d.watches.Notify(ctx, name, linux.IN_CREATE, 0, vfs.PathEvent, false /* unlinked */)
 	return childVFSFD, nil
 }
 
+// openTmpfile implements OpenAt for O_TMPFILE, creating an unnamed regular
+// file in the directory at rp.
+func (fs *filesystem) openTmpfile(ctx context.Context, rp *vfs.ResolvingPath, opts *vfs.OpenOptions) (*vfs.FileDescription, error) {
+	var ds *[]*dentry
+	fs.renameMu.RLock()
+	defer fs.renameMuRUnlockAndCheckCaching(ctx, &ds)
+	d, err := fs.resolveLocked(ctx, rp, &ds)
+	if err != nil {
+		return nil, err
+	}
+	if !d.isDir() {
+		return nil, syserror.ENOTDIR
+	}
+	// Synthetic directories have no remote file in which to create the new
+	// file. When regular files use special file descriptions, the open fid
+	// can't double as the dentry's fid, as it does below.
+	if d.isSynthetic() || fs.opts.regularFilesUseSpecialFileFD {
+		return nil, syserror.EOPNOTSUPP
+	}
+	if err := d.checkPermissions(rp.Credentials(), vfs.MayWrite|vfs.MayExec); err != nil {
+		return nil, err
+	}
+	if d.isDeleted() {
+		return nil, syserror.ENOENT
+	}
+	mnt := rp.Mount()
+	if err := mnt.CheckBeginWrite(); err != nil {
+		return nil, err
+	}
+	defer mnt.EndWrite()
+
+	uid, gid, err := fs.p9Owner(rp.Credentials())
+	if err != nil {
+		return nil, err
+	}
+
+	// As with lcreate, the directory fid is converted into an open fid
+	// representing the created file, so we need to duplicate it first.
+	_, dirfile, err := d.file.walk(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	createFlags := p9.OpenFlags(opts.Flags) & p9.OpenFlagsModeMask
+	if opts.Flags&linux.O_EXCL != 0 {
+		createFlags |= p9.OpenExclusive
+	}
+	fdobj, openFile, createQID, _, err := dirfile.createTmpfile(ctx, createFlags, (p9.FileMode)(opts.Mode), uid, gid)
+	if err != nil {
+		dirfile.close(ctx)
+		if err == syserror.ENOSYS {
+			// The server is too old to support O_TMPFILE.
+			return nil, syserror.EOPNOTSUPP
+		}
+		return nil, err
+	}
+	// The created file has no name, so there is no way to get a non-open fid
+	// representing it; instead, the open fid is also used as the dentry's
+	// fid.
+	_, attrMask, attr, err := openFile.getAttr(ctx, dentryAttrMask())
+	if err != nil {
+		openFile.close(ctx)
+		if fdobj != nil {
+			fdobj.Close()
+		}
+		return nil, err
+	}
+	child, err := fs.newDentry(ctx, openFile, createQID, attrMask, &attr)
+	if err != nil {
+		openFile.close(ctx)
+		if fdobj != nil {
+			fdobj.Close()
+		}
+		return nil, err
+	}
+	ds = appendDentry(ds, child)
+	// The server always opens the file for reading and writing.
+	openFD := int32(-1)
+	if fdobj != nil {
+		openFD = int32(fdobj.Release())
+	}
+	child.handleMu.Lock()
+	child.readFile = openFile
+	child.writeFile = openFile
+	child.readFD = openFD
+	child.writeFD = openFD
+	child.mmapFD = openFD
+	child.handleMu.Unlock()
+	if opts.Flags&linux.O_EXCL == 0 {
+		child.linkable = 1
+	}
+
+	// child is never inserted into d.children, but has d as its parent for
+	// the purposes of inotify and path generation. It is deleted from birth.
+	d.IncRef() // reference held by child on its parent
+	child.parent = d
+	child.name = fmt.Sprintf("#%d", child.ino)
+	child.setDeleted()
+	vfsObj := rp.VirtualFilesystem()
+	mntns := vfs.MountNamespaceFromContext(ctx)
+	defer mntns.DecRef(ctx)
+	if err := vfsObj.PrepareDeleteDentry(mntns, &child.vfsd); err != nil {
+		return nil, err
+	}
+	vfsObj.CommitDeleteDentry(ctx, &child.vfsd)
+
+	fd, err := newRegularFileFD(mnt, child, opts.Flags)
+	if err != nil {
+		return nil, err
+	}
+	return &fd.vfsfd, nil
+}
+
 // ReadlinkAt implements vfs.FilesystemImpl.ReadlinkAt.
 func (fs *filesystem) ReadlinkAt(ctx context.Context, rp *vfs.ResolvingPath) (string, error) {
 	var ds *[]*dentry
@@ -1243,10 +1412,11 @@ func (fs *filesystem) ReadlinkAt(ctx context.Context, rp *vfs.ResolvingPath) (st
 
 // RenameAt implements vfs.FilesystemImpl.RenameAt.
 func (fs *filesystem) RenameAt(ctx context.Context, rp *vfs.ResolvingPath, oldParentVD vfs.VirtualDentry, oldName string, opts vfs.RenameOptions) error {
-	if opts.Flags != 0 {
-		// Requires 9P support.
+	if opts.Flags&^(linux.RENAME_NOREPLACE|linux.RENAME_EXCHANGE|linux.RENAME_WHITEOUT) != 0 {
 		return syserror.EINVAL
 	}
+	noReplace := opts.Flags&linux.RENAME_NOREPLACE != 0
+	exchange := opts.Flags&linux.RENAME_EXCHANGE != 0
 
 	var ds *[]*dentry
 	fs.renameMu.Lock()
@@ -1257,6 +1427,9 @@ func (fs *filesystem) RenameAt(ctx context.Context, rp *vfs.ResolvingPath, oldPa
 	}
 	newName := rp.Component()
 	if newName == "." || newName == ".." {
+		if noReplace {
+			return syserror.EEXIST
+		}
 		return syserror.EBUSY
 	}
 	mnt := rp.Mount()
@@ -1303,7 +1476,7 @@ func (fs *filesystem) RenameAt(ctx context.Context, rp *vfs.ResolvingPath, oldPa
 			}
 		}
 	} else {
-		if opts.MustBeDir || rp.MustBeDir() {
+		if opts.MustBeDir || (!exchange && rp.MustBeDir()) {
 			return syserror.ENOTDIR
 		}
 	}
@@ -1324,8 +1497,27 @@ func (fs *filesystem) RenameAt(ctx context.Context, rp *vfs.ResolvingPath, oldPa
 	}
 	var replacedVFSD *vfs.Dentry
 	if replaced != nil {
+		if noReplace {
+			return syserror.EEXIST
+		}
 		replacedVFSD = &replaced.vfsd
-		if replaced.isDir() {
+		if exchange {
+			if err := newParent.mayDelete(creds, replaced); err != nil {
+				return err
+			}
+			if replaced.isDir() {
+				if replaced == oldParent || genericIsAncestorDentry(replaced, oldParent) {
+					return syserror.EINVAL
+				}
+				if oldParent != newParent {
+					if err := replaced.checkPermissions(creds, vfs.MayWrite); err != nil {
+						return err
+					}
+				}
+			} else if rp.MustBeDir() {
+				return syserror.ENOTDIR
+			}
+		} else if replaced.isDir() {
 			if !renamed.isDir() {
 				return syserror.EISDIR
 			}
@@ -1337,6 +1529,14 @@ func (fs *filesystem) RenameAt(ctx context.Context, rp *vfs.ResolvingPath, oldPa
 				return syserror.ENOTDIR
 			}
 		}
+	} else if exchange {
+		return syserror.ENOENT
+	}
+	// Synthetic files exist only in the sentry, so they can't be exchanged,
+	// and a whiteout can't be created in their place on the remote
+	// filesystem.
+	if opts.Flags&(linux.RENAME_EXCHANGE|linux.RENAME_WHITEOUT) != 0 && (renamed.isSynthetic() || (replaced != nil && replaced.isSynthetic())) {
+		return syserror.EINVAL
 	}
 
 	if oldParent == newParent && oldName == newName {
@@ -1349,7 +1549,16 @@ func (fs *filesystem) RenameAt(ctx context.Context, rp *vfs.ResolvingPath, oldPa
 	}
 
 	// Update the remote filesystem.
-	if !renamed.isSynthetic() {
+	if opts.Flags != 0 && !renamed.isSynthetic() {
+		if err := oldParent.file.renameAtFlags(ctx, oldName, newParent.file, newName, opts.Flags); err != nil {
+			vfsObj.AbortRenameDentry(&renamed.vfsd, replacedVFSD)
+			if err == syserror.ENOSYS {
+				// The server doesn't support renameat2 flags.
+				return syserror.EINVAL
+			}
+			return err
+		}
+	} else if !renamed.isSynthetic() {
 		if err := renamed.file.rename(ctx, newParent.file, newName); err != nil {
 			vfsObj.AbortRenameDentry(&renamed.vfsd, replacedVFSD)
 			return err
@@ -1367,6 +1576,11 @@ func (fs *filesystem) RenameAt(ctx context.Context, rp *vfs.ResolvingPath, oldPa
 		}
 	}
 
+	if exchange {
+		fs.commitRenameExchangeLocked(ctx, vfsObj, oldParent, oldName, renamed, newParent, newName, replaced)
+		return nil
+	}
+
 	// Update the dentry tree.
 	vfsObj.CommitRenameReplaceDentry(ctx, &renamed.vfsd, replacedVFSD)
 	if replaced != nil {
@@ -1374,10 +1588,17 @@ func (fs *filesystem) RenameAt(ctx context.Context, rp *vfs.ResolvingPath, oldPa
 		if replaced.isSynthetic() {
 			newParent.syntheticChildren--
 			replaced.decRefNoCaching()
+		} else if replaced.hostListener != nil {
+			replaced.stopHostListener()
 		}
 		ds = appendDentry(ds, replaced)
 	}
-	oldParent.cacheNegativeLookupLocked(oldName)
+	if opts.Flags&linux.RENAME_WHITEOUT != 0 {
+		// oldName is now a whiteout, which we have no dentry for.
+		delete(oldParent.children, oldName)
+	} else {
+		oldParent.cacheNegativeLookupLocked(oldName)
+	}
 	// We don't use newParent.cacheNewChildLocked() since we don't want to mess
 	// with reference counts and queue oldParent for checkCachingLocked if the
 	// parent isn't actually changing.
@@ -1400,6 +1621,8 @@ func (fs *filesystem) RenameAt(ctx context.Context, rp *vfs.ResolvingPath, oldPa
 	// Update metadata.
 	if renamed.cachedMetadataAuthoritative() {
 		renamed.touchCtime()
+	} else {
+		renamed.invalidateMetadata()
 	}
 	if oldParent.cachedMetadataAuthoritative() {
 		oldParent.dirents = nil
@@ -1407,6 +1630,8 @@ func (fs *filesystem) RenameAt(ctx context.Context, rp *vfs.ResolvingPath, oldPa
 		if renamed.isDir() {
 			oldParent.decLinks()
 		}
+	} else {
+		oldParent.invalidateMetadata()
 	}
 	if newParent.cachedMetadataAuthoritative() {
 		newParent.dirents = nil
@@ -1415,11 +1640,73 @@ func (fs *filesystem) RenameAt(ctx context.Context, rp *vfs.ResolvingPath, oldPa
 			// Increase the link count if we did not replace another directory.
 			newParent.incLinks()
 		}
+	} else {
+		newParent.invalidateMetadata()
 	}
+	if replaced != nil {
+		replaced.invalidateMetadata()
+	}
+	oldParent.noteLocalEvent()
+	newParent.noteLocalEvent()
+	renamed.noteLocalEvent()
 	vfs.InotifyRename(ctx, &renamed.watches, &oldParent.watches, &newParent.watches, oldName, newName, renamed.isDir())
 	return nil
 }
 
+// commitRenameExchangeLocked updates the dentry tree after renamed, the child
+// of oldParent named oldName, is exchanged with replaced, the child of
+// newParent named newName, on the remote filesystem.
+//
+// Preconditions:
+// * fs.renameMu must be locked.
+// * oldParent.dirMu and newParent.dirMu must be locked.
+// * Neither renamed nor replaced is synthetic.
+func (fs *filesystem) commitRenameExchangeLocked(ctx context.Context, vfsObj *vfs.VirtualFilesystem, oldParent *dentry, oldName string, renamed *dentry, newParent *dentry, newName string, replaced *dentry) {
+	vfsObj.CommitRenameExchangeDentry(&renamed.vfsd, &replaced.vfsd)
+	// Since each parent gains one child and loses another, their references
+	// don't change.
+	renamed.parent, replaced.parent = newParent, oldParent
+	renamed.name, replaced.name = newName, oldName
+	oldParent.children[oldName] = replaced
+	newParent.children[newName] = renamed
+
+	// Update metadata.
+	for _, d := range []*dentry{renamed, replaced} {
+		if d.cachedMetadataAuthoritative() {
+			d.touchCtime()
+		} else {
+			d.invalidateMetadata()
+		}
+	}
+	// Link counts change if a directory is exchanged with a non-directory in
+	// another parent.
+	linksMoved := oldParent != newParent && renamed.isDir() != replaced.isDir()
+	for _, p := range []struct {
+		d         *dentry
+		gainedDir bool
+	}{{oldParent, replaced.isDir()}, {newParent, renamed.isDir()}} {
+		if !p.d.cachedMetadataAuthoritative() {
+			p.d.invalidateMetadata()
+			continue
+		}
+		p.d.dirents = nil
+		p.d.touchCMtime()
+		if linksMoved {
+			if p.gainedDir {
+				p.d.incLinks()
+			} else {
+				p.d.decLinks()
+			}
+		}
+	}
+	oldParent.noteLocalEvent()
+	newParent.noteLocalEvent()
+	renamed.noteLocalEvent()
+	replaced.noteLocalEvent()
+	vfs.InotifyRename(ctx, &renamed.watches, &oldParent.watches, &newParent.watches, oldName, newName, renamed.isDir())
+	vfs.InotifyRename(ctx, &replaced.watches, &newParent.watches, &oldParent.watches, newName, oldName, replaced.isDir())
+}
+
 // RmdirAt implements vfs.FilesystemImpl.RmdirAt.
 func (fs *filesystem) RmdirAt(ctx context.Context, rp *vfs.ResolvingPath) error {
 	return fs.unlinkAt(ctx, rp, true /* dir */)
@@ -1503,7 +1790,11 @@ func (fs *filesystem) StatFSAt(ctx context.Context, rp *vfs.ResolvingPath) (linu
 func (fs *filesystem) SymlinkAt(ctx context.Context, rp *vfs.ResolvingPath, target string) error {
 	return fs.doCreateAt(ctx, rp, false /* dir */, func(parent *dentry, name string, _ **[]*dentry) error {
 		creds := rp.Credentials()
-		_, err := parent.file.symlink(ctx, target, name, (p9.UID)(creds.EffectiveKUID), (p9.GID)(creds.EffectiveKGID))
+		uid, gid, err := fs.p9Owner(creds)
+		if err != nil {
+			return err
+		}
+		_, err = parent.file.symlink(ctx, target, name, uid, gid)
 		return err
 	}, nil)
 }
@@ -1526,6 +1817,13 @@ func (fs *filesystem) BoundEndpointAt(ctx context.Context, rp *vfs.ResolvingPath
 		return nil, err
 	}
 	if d.isSocket() {
+		if d.endpoint != nil {
+			// d is either synthetic, or was created by binding d.endpoint
+			// and is also reachable from the host. In the latter case,
+			// connect to the endpoint directly rather than through the
+			// host.
+			return d.endpoint, nil
+		}
 		if !d.isSynthetic() {
 			d.IncRef()
 			return &endpoint{
@@ -1533,9 +1831,6 @@ func (fs *filesystem) BoundEndpointAt(ctx context.Context, rp *vfs.ResolvingPath
 				path:   opts.Addr,
 			}, nil
 		}
-		if d.endpoint != nil {
-			return d.endpoint, nil
-		}
 	}
 	return nil, syserror.ECONNREFUSED
 }
@@ -117,10 +117,33 @@ type filesystem struct {
 	cachedDentriesLen uint64
 
 	// syncableDentries contains all non-synthetic dentries. specialFileFDs
-	// contains all open specialFileFDs. These fields are protected by syncMu.
-	syncMu           sync.Mutex `state:"nosave"`
-	syncableDentries map[*dentry]struct{}
-	specialFileFDs   map[*specialFileFD]struct{}
+	// contains all open specialFileFDs. If filesystemOptions.invalidations is
+	// true, dentriesByQIDPath maps the QID paths of all non-synthetic dentries
+	// to them. These fields are protected by syncMu.
+	syncMu            sync.Mutex `state:"nosave"`
+	syncableDentries  map[*dentry]struct{}
+	specialFileFDs    map[*specialFileFD]struct{}
+	dentriesByQIDPath map[uint64]map[*dentry]struct{} `state:"nosave"`
+
+	// invalidating is non-zero if the server reports the changes made to
+	// files, such that cached metadata is trusted until it is invalidated.
+	// invalidationEpoch is incremented when all cached metadata is
+	// invalidated. These fields are accessed using atomic memory operations.
+	// See invalidations.go.
+	invalidating      int32  `state:"nosave"`
+	invalidationEpoch uint64 `state:"nosave"`
+
+	// remoteLocksUnsupported is non-zero if the server doesn't support
+	// record locks. It is accessed using atomic memory operations. See
+	// lock.go.
+	remoteLocksUnsupported int32 `state:"nosave"`
+
+	// dirtyBytes is the approximate amount of dirty cached data in the
+	// filesystem. It is accessed using atomic memory operations. flusherWake
+	// wakes up the background flusher. These fields are only used if
+	// filesystemOptions.dirtyBytes is non-zero. See writeback.go.
+	dirtyBytes  int64         `state:"nosave"`
+	flusherWake chan struct{} `state:"nosave"`
 
 	// inoByQIDPath maps previously-observed QID.Paths to inode numbers
 	// assigned to those paths. inoByQIDPath is not preserved across
@@ -153,15 +176,29 @@ type filesystemOptions struct {
 	msize   uint32
 	version string
 
+	// uidMap and gidMap map UIDs and GIDs for ID-mapped mounts, which
+	// present files with different owners than in the remote filesystem.
+	// See idMap.
+	uidMap idMap
+	gidMap idMap
+
+	// channels is the maximum number of channels used to send concurrent
+	// requests to the server. If 0, p9 selects a default.
+	channels int
+
 	// maxCachedDentries is the maximum size of filesystem.cachedDentries.
 	maxCachedDentries uint64
 
+	// readahead is the maximum number of bytes read into the page cache from
+	// regular files at a time, unless more are required. It is page-aligned.
+	readahead uint64
+
 	// If forcePageCache is true, host FDs may not be used for application
 	// memory mappings even if available; instead, the client must perform its
 	// own caching of regular file pages. This is primarily useful for testing.
 	forcePageCache bool
 
-	// If limitHostFDTranslation is true, apply maxFillRange() constraints to
+	// If limitHostFDTranslation is true, apply fs.maxFillRange() constraints to
 	// host FD mappings returned by dentry.(memmap.Mappable).Translate(). This
 	// makes memory accounting behavior more consistent between cases where
 	// host FDs are / are not available, but may increase the frequency of
@@ -183,6 +220,30 @@ type filesystemOptions struct {
 	// way that application FDs representing "special files" such as sockets
 	// do. Note that this disables client caching and mmap for regular files.
 	regularFilesUseSpecialFileFD bool
+
+	// If remoteLocks is true, POSIX record locks and BSD locks are also taken
+	// on remote files. See lock.go.
+	remoteLocks bool
+
+	// If hostUDSCreate is true, binding a stream or seqpacket Unix domain
+	// socket to a path creates a socket file on the remote filesystem that
+	// host processes can connect to, if the server allows it. See
+	// hostListener.
+	hostUDSCreate bool
+
+	// If invalidations is true, InteropModeShared is in effect and cached
+	// metadata is only revalidated once the server reports that it may have
+	// changed, if the server supports it.
+	invalidations bool
+
+	// If dirtyBytes is non-zero, InteropModeExclusive is in effect and writes
+	// to regular files are cached until written back, with at most dirtyBytes
+	// of dirty data in the filesystem before writers write back their dirty
+	// data themselves. dirtyBackgroundBytes is the amount of dirty data above
+	// which the background flusher writes back dirty data regardless of its
+	// age. See writeback.go.
+	dirtyBytes           uint64
+	dirtyBackgroundBytes uint64
 }
 
 // InteropMode controls the client's interaction with other remote filesystem
@@ -263,6 +324,12 @@ type InternalFilesystemOptions struct {
 	// If OpenSocketsByConnecting is true, silently translate attempts to open
 	// files identifying as sockets to connect RPCs.
 	OpenSocketsByConnecting bool
+
+	// If ReadCache is not nil, the contents of remote files read through the
+	// filesystem are cached in it. It must only be used by filesystems that
+	// are not written to through the sandbox. ReadCache is not preserved
+	// across checkpoint/restore.
+	ReadCache *ReadCache `state:"nosave"`
 }
 
 // _V9FS_DEFUID and _V9FS_DEFGID (from Linux's fs/9p/v9fs.h) are the default
@@ -321,12 +388,31 @@ func (fstype FilesystemType) GetFilesystem(ctx context.Context, vfsObj *vfs.Virt
 			fallthrough
 		case "remote_revalidating":
 			fsopts.interop = InteropModeShared
+		case "remote_invalidating":
+			fsopts.interop = InteropModeShared
+			fsopts.invalidations = true
 		default:
 			ctx.Warningf("gofer.FilesystemType.GetFilesystem: invalid cache policy: cache=%s", cache)
 			return nil, nil, syserror.EINVAL
 		}
 	}
 
+	// Parse the locking policy. Locks are taken remotely by default if files
+	// may be shared with other clients.
+	fsopts.remoteLocks = fsopts.interop == InteropModeShared
+	if locks, ok := mopts["locks"]; ok {
+		delete(mopts, "locks")
+		switch locks {
+		case "local":
+			fsopts.remoteLocks = false
+		case "remote":
+			fsopts.remoteLocks = true
+		default:
+			ctx.Warningf("gofer.FilesystemType.GetFilesystem: invalid locking policy: locks=%s", locks)
+			return nil, nil, syserror.EINVAL
+		}
+	}
+
 	// Parse the default UID and GID.
 	fsopts.dfltuid = _V9FS_DEFUID
 	if dfltuidstr, ok := mopts["dfltuid"]; ok {
@@ -352,6 +438,26 @@ func (fstype FilesystemType) GetFilesystem(ctx context.Context, vfsObj *vfs.Virt
 		fsopts.dfltgid = auth.KGID(dfltgid)
 	}
 
+	// Parse the ID mappings.
+	if str, ok := mopts["uidmap"]; ok {
+		delete(mopts, "uidmap")
+		uidMap, err := parseIDMap(str)
+		if err != nil {
+			ctx.Warningf("gofer.FilesystemType.GetFilesystem: invalid UID mapping: uidmap=%s: %v", str, err)
+			return nil, nil, syserror.EINVAL
+		}
+		fsopts.uidMap = uidMap
+	}
+	if str, ok := mopts["gidmap"]; ok {
+		delete(mopts, "gidmap")
+		gidMap, err := parseIDMap(str)
+		if err != nil {
+			ctx.Warningf("gofer.FilesystemType.GetFilesystem: invalid GID mapping: gidmap=%s: %v", str, err)
+			return nil, nil, syserror.EINVAL
+		}
+		fsopts.gidMap = gidMap
+	}
+
 	// Parse the 9P message size.
 	fsopts.msize = 1024 * 1024 // 1M, tested to give good enough performance up to 64M
 	if msizestr, ok := mopts["msize"]; ok {
@@ -371,6 +477,42 @@ func (fstype FilesystemType) GetFilesystem(ctx context.Context, vfsObj *vfs.Virt
 		fsopts.version = version
 	}
 
+	// Parse the number of channels used to send concurrent requests.
+	if str, ok := mopts["channels"]; ok {
+		delete(mopts, "channels")
+		channels, err := strconv.ParseUint(str, 10, 32)
+		if err != nil || channels == 0 {
+			ctx.Warningf("gofer.FilesystemType.GetFilesystem: invalid number of channels: channels=%s", str)
+			return nil, nil, syserror.EINVAL
+		}
+		fsopts.channels = int(channels)
+	}
+
+	// Parse the dirty data limits for write-back caching.
+	if str, ok := mopts["dirty_bytes"]; ok {
+		delete(mopts, "dirty_bytes")
+		dirtyBytes, err := strconv.ParseUint(str, 10, 63)
+		if err != nil {
+			ctx.Warningf("gofer.FilesystemType.GetFilesystem: invalid dirty data limit: dirty_bytes=%s", str)
+			return nil, nil, syserror.EINVAL
+		}
+		if dirtyBytes != 0 && fsopts.interop != InteropModeExclusive {
+			ctx.Warningf("gofer.FilesystemType.GetFilesystem: dirty_bytes requires cache=fscache")
+			return nil, nil, syserror.EINVAL
+		}
+		fsopts.dirtyBytes = dirtyBytes
+		fsopts.dirtyBackgroundBytes = dirtyBytes / 2
+	}
+	if str, ok := mopts["dirty_background_bytes"]; ok {
+		delete(mopts, "dirty_background_bytes")
+		dirtyBackgroundBytes, err := strconv.ParseUint(str, 10, 63)
+		if err != nil || dirtyBackgroundBytes > fsopts.dirtyBytes {
+			ctx.Warningf("gofer.FilesystemType.GetFilesystem: invalid background dirty data limit: dirty_background_bytes=%s", str)
+			return nil, nil, syserror.EINVAL
+		}
+		fsopts.dirtyBackgroundBytes = dirtyBackgroundBytes
+	}
+
 	// Parse the dentry cache limit.
 	fsopts.maxCachedDentries = 1000
 	if str, ok := mopts["dentry_cache_limit"]; ok {
@@ -383,6 +525,18 @@ func (fstype FilesystemType) GetFilesystem(ctx context.Context, vfsObj *vfs.Virt
 		fsopts.maxCachedDentries = maxCachedDentries
 	}
 
+	// Parse the readahead size.
+	fsopts.readahead = 64 << 10 // 64 KB, chosen arbitrarily
+	if str, ok := mopts["readahead"]; ok {
+		delete(mopts, "readahead")
+		readahead, err := strconv.ParseUint(str, 10, 32)
+		if err != nil {
+			ctx.Warningf("gofer.FilesystemType.GetFilesystem: invalid readahead size: readahead=%s", str)
+			return nil, nil, syserror.EINVAL
+		}
+		fsopts.readahead, _ = usermem.PageRoundUp(readahead)
+	}
+
 	// Handle simple flags.
 	if _, ok := mopts["force_page_cache"]; ok {
 		delete(mopts, "force_page_cache")
@@ -396,6 +550,10 @@ func (fstype FilesystemType) GetFilesystem(ctx context.Context, vfsObj *vfs.Virt
 		delete(mopts, "overlayfs_stale_read")
 		fsopts.overlayfsStaleRead = true
 	}
+	if _, ok := mopts["host_uds_create"]; ok {
+		delete(mopts, "host_uds_create")
+		fsopts.hostUDSCreate = true
+	}
 	// fsopts.regularFilesUseSpecialFileFD can only be enabled by specifying
 	// "cache=none".
 
@@ -428,6 +586,9 @@ func (fstype FilesystemType) GetFilesystem(ctx context.Context, vfsObj *vfs.Virt
 		specialFileFDs:   make(map[*specialFileFD]struct{}),
 		inoByQIDPath:     make(map[uint64]uint64),
 	}
+	if fsopts.invalidations {
+		fs.dentriesByQIDPath = make(map[uint64]map[*dentry]struct{})
+	}
 	fs.vfsfs.Init(vfsObj, &fstype, fs)
 
 	// Connect to the server.
@@ -464,6 +625,14 @@ func (fstype FilesystemType) GetFilesystem(ctx context.Context, vfsObj *vfs.Virt
 	root.refs = 2
 	fs.root = root
 
+	if fsopts.invalidations {
+		go fs.watchInvalidations() // S/R-SAFE: restarted by CompleteRestore.
+	}
+	if fsopts.dirtyBytes != 0 {
+		fs.flusherWake = make(chan struct{}, 1)
+		go fs.runFlusher() // S/R-SAFE: see filesystem.PrepareSave.
+	}
+
 	return &fs.vfsfs, &root.vfsd, nil
 }
 
@@ -516,7 +685,7 @@ func (fs *filesystem) dial(ctx context.Context) error {
 
 	// Perform version negotiation with the server.
 	ctx.UninterruptibleSleepStart(false)
-	client, err := p9.NewClient(conn, fs.opts.msize, fs.opts.version)
+	client, err := p9.NewClientWithOpts(conn, fs.opts.msize, fs.opts.version, p9.ClientOpts{Channels: fs.opts.channels})
 	ctx.UninterruptibleSleepFinish(false)
 	if err != nil {
 		conn.Close()
@@ -531,6 +700,9 @@ func (fs *filesystem) dial(ctx context.Context) error {
 // Release implements vfs.FilesystemImpl.Release.
 func (fs *filesystem) Release(ctx context.Context) {
 	atomic.StoreInt32(&fs.released, 1)
+	if fs.opts.dirtyBytes != 0 {
+		fs.wakeFlusher()
+	}
 
 	mf := fs.mfp.MemoryFile()
 	fs.syncMu.Lock()
@@ -597,6 +769,9 @@ func (d *dentry) releaseSyntheticRecursiveLocked(ctx context.Context) {
 	if d.isSynthetic() {
 		d.decRefNoCaching()
 		d.checkCachingLocked(ctx)
+	} else if d.hostListener != nil {
+		d.stopHostListener()
+		d.checkCachingLocked(ctx)
 	}
 	if d.isDir() {
 		var children []*dentry
@@ -654,6 +829,12 @@ type dentry struct {
 	// deleted. deleted is accessed using atomic memory operations.
 	deleted uint32
 
+	// If linkable is non-zero, the file represented by this dentry was
+	// created by open(O_TMPFILE) without O_EXCL and has not yet been linked,
+	// so LinkAt may give it a link despite nlink being 0. linkable is
+	// accessed using atomic memory operations.
+	linkable uint32
+
 	// If cached is true, dentryEntry links dentry into
 	// filesystem.cachedDentries. cached and dentryEntry are protected by
 	// filesystem.renameMu.
@@ -667,8 +848,8 @@ type dentry struct {
 	// - Mappings of child filenames to dentries representing those children.
 	//
 	// - Mappings of child filenames that are known not to exist to nil
-	// dentries (only if InteropModeShared is not in effect and the directory
-	// is not synthetic).
+	// dentries (only if InteropModeShared is not in effect, or the server
+	// reports invalidations, and the directory is not synthetic).
 	//
 	// children is protected by dirMu.
 	children map[string]*dentry
@@ -723,6 +904,18 @@ type dentry struct {
 	// other metadata fields.
 	nlink uint32
 
+	// If filesystem.invalidating is non-zero, metadataGen and validGen
+	// determine whether cached metadata is up to date without revalidating
+	// it. They are accessed using atomic memory operations. See
+	// invalidations.go.
+	metadataGen uint64 `state:"nosave"`
+	validGen    uint64 `state:"nosave"`
+
+	// lastLocalEvent is the time in nanoseconds at which an inotify event
+	// was last generated locally for this dentry. It is accessed using atomic
+	// memory operations. See invalidations.go.
+	lastLocalEvent int64 `state:"nosave"`
+
 	mapsMu sync.Mutex `state:"nosave"`
 
 	// If this dentry represents a regular file, mappings tracks mappings of
@@ -775,6 +968,13 @@ type dentry struct {
 	// tracks dirty segments in cache. dirty is protected by dataMu.
 	dirty fsutil.DirtySet
 
+	// dirtyBytes is the approximate amount of dirty data in cache, and
+	// dirtiedAt is the time in nanoseconds at which dirtyBytes last became
+	// non-zero. They are only mutated with dataMu locked, and are accessed
+	// using atomic memory operations. See writeback.go.
+	dirtyBytes int64 `state:"nosave"`
+	dirtiedAt  int64 `state:"nosave"`
+
 	// pf implements platform.File for mappings of hostFD.
 	pf dentryPlatformFile
 
@@ -784,16 +984,31 @@ type dentry struct {
 	haveTarget bool
 	target     string
 
-	// If this dentry represents a synthetic socket file, endpoint is the
-	// transport endpoint bound to this file.
+	// If this dentry represents a synthetic socket file, or a remote socket
+	// file created by binding a socket, endpoint is the transport endpoint
+	// bound to this file.
 	endpoint transport.BoundEndpoint
 
+	// If this dentry represents a remote socket file created by binding a
+	// socket, hostListener passes connections to the file from outside the
+	// sandbox to endpoint. An additional reference is held on the dentry
+	// while hostListener is non-nil. hostListener is protected by the parent
+	// dentry's dirMu.
+	hostListener *hostListener `state:"nosave"`
+
 	// If this dentry represents a synthetic named pipe, pipe is the pipe
 	// endpoint bound to this file.
 	pipe *pipe.VFSPipe
 
 	locks vfs.FileLocks
 
+	// If filesystem.remoteLocking() is true, remoteLocks maps the owners of
+	// POSIX record locks and BSD locks on the remote file to the handles
+	// through which they hold them. remoteLocks is protected by remoteLocksMu. See
+	// lock.go.
+	remoteLocksMu sync.Mutex                 `state:"nosave"`
+	remoteLocks   map[fslock.UniqueID]handle `state:"nosave"`
+
 	// Inotify watches for this dentry.
 	//
 	// Note that inotify may behave unexpectedly in the presence of hard links,
@@ -851,10 +1066,10 @@ func (fs *filesystem) newDentry(ctx context.Context, file p9file, qid p9.QID, ma
 	}
 	d.pf.dentry = d
 	if mask.UID {
-		d.uid = dentryUIDFromP9UID(attr.UID)
+		d.uid = fs.dentryUIDFromP9UID(attr.UID)
 	}
 	if mask.GID {
-		d.gid = dentryGIDFromP9GID(attr.GID)
+		d.gid = fs.dentryGIDFromP9GID(attr.GID)
 	}
 	if mask.Size {
 		d.size = attr.Size
@@ -881,6 +1096,7 @@ func (fs *filesystem) newDentry(ctx context.Context, file p9file, qid p9.QID, ma
 	refsvfs2.Register(d)
 	fs.syncMu.Lock()
 	fs.syncableDentries[d] = struct{}{}
+	fs.addDentryQIDPathLocked(d)
 	fs.syncMu.Unlock()
 	return d, nil
 }
@@ -920,10 +1136,10 @@ func (d *dentry) updateFromP9AttrsLocked(mask p9.AttrMask, attr *p9.Attr) {
 		atomic.StoreUint32(&d.mode, uint32(attr.Mode))
 	}
 	if mask.UID {
-		atomic.StoreUint32(&d.uid, dentryUIDFromP9UID(attr.UID))
+		atomic.StoreUint32(&d.uid, d.fs.dentryUIDFromP9UID(attr.UID))
 	}
 	if mask.GID {
-		atomic.StoreUint32(&d.gid, dentryGIDFromP9GID(attr.GID))
+		atomic.StoreUint32(&d.gid, d.fs.dentryGIDFromP9GID(attr.GID))
 	}
 	// There is no P9_GETATTR_* bit for I/O block size.
 	if attr.BlockSize != 0 {
@@ -968,6 +1184,10 @@ func (d *dentry) updateFromGetattr(ctx context.Context) error {
 	// updating stale attributes in d.updateFromP9AttrsLocked().
 	d.metadataMu.Lock()
 	defer d.metadataMu.Unlock()
+	if d.metadataValid() {
+		return nil
+	}
+	gen := d.metadataGeneration()
 	d.handleMu.RLock()
 	if !d.writeFile.isNil() {
 		file = d.writeFile
@@ -987,6 +1207,7 @@ func (d *dentry) updateFromGetattr(ctx context.Context) error {
 		return err
 	}
 	d.updateFromP9AttrsLocked(attrMask, &attr)
+	d.setMetadataValid(gen)
 	return nil
 }
 
@@ -1076,6 +1297,20 @@ func (d *dentry) setStat(ctx context.Context, creds *auth.Credentials, opts *vfs
 	defer d.metadataMu.Unlock()
 	if !d.isSynthetic() {
 		if stat.Mask != 0 {
+			var uid p9.UID
+			if stat.Mask&linux.STATX_UID != 0 {
+				var err error
+				if uid, err = d.fs.p9UID(auth.KUID(stat.UID)); err != nil {
+					return err
+				}
+			}
+			var gid p9.GID
+			if stat.Mask&linux.STATX_GID != 0 {
+				var err error
+				if gid, err = d.fs.p9GID(auth.KGID(stat.GID)); err != nil {
+					return err
+				}
+			}
 			if err := d.file.setAttr(ctx, p9.SetAttrMask{
 				Permissions:        stat.Mask&linux.STATX_MODE != 0,
 				UID:                stat.Mask&linux.STATX_UID != 0,
@@ -1087,8 +1322,8 @@ func (d *dentry) setStat(ctx context.Context, creds *auth.Credentials, opts *vfs
 				MTimeNotSystemTime: stat.Mask&linux.STATX_MTIME != 0 && stat.Mtime.Nsec != linux.UTIME_NOW,
 			}, p9.SetAttr{
 				Permissions:      p9.FileMode(stat.Mode),
-				UID:              p9.UID(stat.UID),
-				GID:              p9.GID(stat.GID),
+				UID:              uid,
+				GID:              gid,
 				Size:             stat.Size,
 				ATimeSeconds:     uint64(stat.Atime.Sec),
 				ATimeNanoSeconds: uint64(stat.Atime.Nsec),
@@ -1109,6 +1344,7 @@ func (d *dentry) setStat(ctx context.Context, creds *auth.Credentials, opts *vfs
 			// it'll be overwritten by revalidation before the next time it's
 			// used anyway. (InteropModeShared inhibits client caching of
 			// regular file data, so there's no cache to truncate either.)
+			d.invalidateMetadata()
 			return nil
 		}
 	}
@@ -1157,6 +1393,8 @@ func (d *dentry) doAllocate(ctx context.Context, offset, length uint64, allocate
 	d.updateSizeLocked(size)
 	if d.cachedMetadataAuthoritative() {
 		d.touchCMtimeLocked()
+	} else {
+		d.invalidateMetadata()
 	}
 	return nil
 }
@@ -1192,6 +1430,7 @@ func (d *dentry) updateSizeLocked(newSize uint64) {
 		d.dataMu.Lock()
 		d.cache.Truncate(d.size, d.fs.mfp.MemoryFile())
 		d.dirty.KeepClean(memmap.MappableRange{d.size, oldpgend})
+		d.cleanedLocked()
 		d.dataMu.Unlock()
 	}
 }
@@ -1219,20 +1458,6 @@ func (d *dentry) mayDelete(creds *auth.Credentials, child *dentry) error {
 	return vfs.CheckDeleteSticky(creds, linux.FileMode(atomic.LoadUint32(&d.mode)), auth.KUID(atomic.LoadUint32(&child.uid)))
 }
 
-func dentryUIDFromP9UID(uid p9.UID) uint32 {
-	if !uid.Ok() {
-		return uint32(auth.OverflowUID)
-	}
-	return uint32(uid)
-}
-
-func dentryGIDFromP9GID(gid p9.GID) uint32 {
-	if !gid.Ok() {
-		return uint32(auth.OverflowGID)
-	}
-	return uint32(gid)
-}
-
 // IncRef implements vfs.DentryImpl.IncRef.
 func (d *dentry) IncRef() {
 	// d.refs may be 0 if d.fs.renameMu is locked, which serializes against
@@ -1307,8 +1532,10 @@ func (d *dentry) InotifyWithParent(ctx context.Context, events, cookie uint32, e
 	}
 
 	d.fs.renameMu.RLock()
+	d.noteLocalEvent()
 	// The ordering below is important, Linux always notifies the parent first.
 	if d.parent != nil {
+		d.parent.noteLocalEvent()
 		d.parent.watches.Notify(ctx, d.name, events, cookie, et, d.isDeleted())
 	}
 	d.watches.Notify(ctx, "", events, cookie, et, d.isDeleted())
@@ -1488,12 +1715,14 @@ func (d *dentry) destroyLocked(ctx context.Context) {
 		d.cache.DropAll(mf)
 		d.dirty.RemoveAll()
 	}
+	d.cleanedLocked()
 	d.dataMu.Unlock()
-	// Clunk open fids and close open host FDs.
-	if !d.readFile.isNil() {
+	// Clunk open fids and close open host FDs. If d was created by
+	// open(O_TMPFILE), its open fid is also d.file, which is clunked below.
+	if !d.readFile.isNil() && d.readFile != d.file {
 		d.readFile.close(ctx)
 	}
-	if !d.writeFile.isNil() && d.readFile != d.writeFile {
+	if !d.writeFile.isNil() && d.readFile != d.writeFile && d.writeFile != d.file {
 		d.writeFile.close(ctx)
 	}
 	d.readFile = p9file{}
@@ -1509,6 +1738,10 @@ func (d *dentry) destroyLocked(ctx context.Context) {
 	d.mmapFD = -1
 	d.handleMu.Unlock()
 
+	d.remoteLocksMu.Lock()
+	d.releaseRemoteLocksLocked(ctx)
+	d.remoteLocksMu.Unlock()
+
 	if !d.file.isNil() {
 		// Note that it's possible that d.atimeDirty or d.mtimeDirty are true,
 		// i.e. client and server timestamps may differ (because e.g. a client
@@ -1526,6 +1759,7 @@ func (d *dentry) destroyLocked(ctx context.Context) {
 		// Remove d from the set of syncable dentries.
 		d.fs.syncMu.Lock()
 		delete(d.fs.syncableDentries, d)
+		d.fs.removeDentryQIDPathLocked(d)
 		d.fs.syncMu.Unlock()
 	}
 
@@ -1829,6 +2063,7 @@ func (d *dentry) syncCachedFile(ctx context.Context, forFilesystemSync bool) err
 		// Write back dirty pages to the remote file.
 		d.dataMu.Lock()
 		err := fsutil.SyncDirtyAll(ctx, &d.cache, &d.dirty, d.size, d.fs.mfp.MemoryFile(), h.writeFromBlocksAt)
+		d.cleanedLocked()
 		d.dataMu.Unlock()
 		if err != nil {
 			return err
@@ -1945,21 +2180,87 @@ func (fd *fileDescription) RemoveXattr(ctx context.Context, name string) error {
 
 // LockBSD implements vfs.FileDescriptionImpl.LockBSD.
 func (fd *fileDescription) LockBSD(ctx context.Context, uid fslock.UniqueID, ownerPID int32, t fslock.LockType, block fslock.Blocker) error {
-	fd.lockLogging.Do(func() {
-		log.Infof("File lock using gofer file handled internally.")
-	})
-	return fd.LockFD.LockBSD(ctx, uid, ownerPID, t, block)
+	d := fd.dentry()
+	if !d.fs.remoteLocking() || d.isSynthetic() {
+		fd.lockLogging.Do(func() {
+			log.Infof("File lock using gofer file handled internally.")
+		})
+		return fd.LockFD.LockBSD(ctx, uid, ownerPID, t, block)
+	}
+
+	if err := d.lockRemoteBSD(ctx, uid, t, block, fd.vfsfd.IsReadable(), fd.vfsfd.IsWritable()); err != nil {
+		if err != syserror.ENOSYS {
+			return err
+		}
+		d.fs.remoteLocksNotSupported(err)
+	}
+	if err := fd.LockFD.LockBSD(ctx, uid, ownerPID, t, block); err != nil {
+		// Don't leave the file locked remotely. A remote lock that uid held
+		// before is released as well.
+		d.unlockRemoteBSD(ctx, uid)
+		return err
+	}
+	return nil
+}
+
+// UnlockBSD implements vfs.FileDescriptionImpl.UnlockBSD.
+func (fd *fileDescription) UnlockBSD(ctx context.Context, uid fslock.UniqueID) error {
+	if err := fd.LockFD.UnlockBSD(ctx, uid); err != nil {
+		return err
+	}
+	// Remote locks may have been taken before remoteLocking() became false.
+	fd.dentry().unlockRemoteBSD(ctx, uid)
+	return nil
 }
 
 // LockPOSIX implements vfs.FileDescriptionImpl.LockPOSIX.
 func (fd *fileDescription) LockPOSIX(ctx context.Context, uid fslock.UniqueID, ownerPID int32, t fslock.LockType, r fslock.LockRange, block fslock.Blocker) error {
-	fd.lockLogging.Do(func() {
-		log.Infof("Range lock using gofer file handled internally.")
-	})
-	return fd.Locks().LockPOSIX(ctx, uid, ownerPID, t, r, block)
+	d := fd.dentry()
+	if !d.fs.remoteLocking() || d.isSynthetic() {
+		fd.lockLogging.Do(func() {
+			log.Infof("Range lock using gofer file handled internally.")
+		})
+		return fd.Locks().LockPOSIX(ctx, uid, ownerPID, t, r, block)
+	}
+
+	if err := d.lockRemote(ctx, uid, t, r, block, fd.vfsfd.IsReadable(), fd.vfsfd.IsWritable()); err != nil {
+		if err != syserror.ENOSYS {
+			return err
+		}
+		d.fs.remoteLocksNotSupported(err)
+	}
+	if err := fd.Locks().LockPOSIX(ctx, uid, ownerPID, t, r, block); err != nil {
+		// Don't leave the range locked remotely. Remote locks that uid held
+		// on the range before are released as well.
+		d.unlockRemote(ctx, uid, r)
+		return err
+	}
+	return nil
 }
 
 // UnlockPOSIX implements vfs.FileDescriptionImpl.UnlockPOSIX.
 func (fd *fileDescription) UnlockPOSIX(ctx context.Context, uid fslock.UniqueID, r fslock.LockRange) error {
-	return fd.Locks().UnlockPOSIX(ctx, uid, r)
+	if err := fd.Locks().UnlockPOSIX(ctx, uid, r); err != nil {
+		return err
+	}
+	// Remote locks may have been taken before remoteLocking() became false.
+	return fd.dentry().unlockRemote(ctx, uid, r)
+}
+
+// TestPOSIX implements vfs.FileDescriptionImpl.TestPOSIX.
+func (fd *fileDescription) TestPOSIX(ctx context.Context, uid fslock.UniqueID, t fslock.LockType, r fslock.LockRange) (linux.Flock, error) {
+	f, err := fd.Locks().TestPOSIX(ctx, uid, t, r)
+	if err != nil || f.Type != linux.F_UNLCK {
+		return f, err
+	}
+	d := fd.dentry()
+	if !d.fs.remoteLocking() || d.isSynthetic() {
+		return f, nil
+	}
+	f, err = d.testRemote(ctx, uid, t, r, fd.vfsfd.IsReadable(), fd.vfsfd.IsWritable())
+	if err == syserror.ENOSYS {
+		d.fs.remoteLocksNotSupported(err)
+		return linux.Flock{Type: linux.F_UNLCK}, nil
+	}
+	return f, err
 }
@@ -15,12 +15,19 @@
 package gofer
 
 import (
+	"bytes"
+	"io/ioutil"
+	"os"
 	"sync/atomic"
 	"testing"
 
+	"golang.org/x/sys/unix"
+	"gvisor.dev/gvisor/pkg/fd"
 	"gvisor.dev/gvisor/pkg/p9"
 	"gvisor.dev/gvisor/pkg/sentry/contexttest"
+	"gvisor.dev/gvisor/pkg/sentry/memmap"
 	"gvisor.dev/gvisor/pkg/sentry/pgalloc"
+	"gvisor.dev/gvisor/pkg/usermem"
 )
 
 func TestDestroyIdempotent(t *testing.T) {
@@ -66,3 +73,127 @@ func TestDestroyIdempotent(t *testing.T) {
 	child.checkCachingLocked(ctx)
 	child.checkCachingLocked(ctx)
 }
+
+func TestIDMap(t *testing.T) {
+	m, err := parseIDMap("0:100000:1000 1000:1000:1")
+	if err != nil {
+		t.Fatalf("parseIDMap failed: %v", err)
+	}
+	for _, test := range []struct {
+		id     uint32
+		remote uint32
+	}{
+		{id: 0, remote: 100000},
+		{id: 999, remote: 100999},
+		{id: 1000, remote: 1000},
+	} {
+		if got, ok := m.toRemote(test.id); !ok || got != test.remote {
+			t.Errorf("toRemote(%d) got (%d, %t), want (%d, true)", test.id, got, ok, test.remote)
+		}
+		if got, ok := m.fromRemote(test.remote); !ok || got != test.id {
+			t.Errorf("fromRemote(%d) got (%d, %t), want (%d, true)", test.remote, got, ok, test.id)
+		}
+	}
+	if got, ok := m.toRemote(1001); ok {
+		t.Errorf("toRemote(1001) got %d, want unmapped", got)
+	}
+	if got, ok := m.fromRemote(0); ok {
+		t.Errorf("fromRemote(0) got %d, want unmapped", got)
+	}
+
+	for _, str := range []string{"", "0:0", "0:0:0", "0:x:1", "4294967295:0:2", "0:0:10 5:100:10", "0:0:10 100:5:10"} {
+		if _, err := parseIDMap(str); err == nil {
+			t.Errorf("parseIDMap(%q) succeeded, want error", str)
+		}
+	}
+}
+
+func TestReadCache(t *testing.T) {
+	f, err := ioutil.TempFile(os.Getenv("TEST_TMPDIR"), "read_cache_test")
+	if err != nil {
+		t.Fatalf("TempFile failed: %v", err)
+	}
+	defer os.Remove(f.Name())
+	if err := f.Truncate(4 << 20); err != nil {
+		t.Fatalf("Truncate failed: %v", err)
+	}
+	f.Close()
+	open := func() *ReadCache {
+		file, err := fd.Open(f.Name(), unix.O_RDWR, 0)
+		if err != nil {
+			t.Fatalf("Open failed: %v", err)
+		}
+		rc, err := NewReadCache(file)
+		if err != nil {
+			t.Fatalf("NewReadCache failed: %v", err)
+		}
+		return rc
+	}
+
+	file := readCacheFile{mount: "/", qidPath: 1, size: 2 * readCacheBlockSize}
+	want := bytes.Repeat([]byte{'a'}, readCacheBlockSize)
+	buf := make([]byte, readCacheBlockSize)
+	rc := open()
+	if rc.lookup(file.key(0), buf) {
+		t.Errorf("lookup of uncached block succeeded")
+	}
+	rc.store(file.key(0), want)
+	rc.Release()
+
+	// The block is still cached by a new ReadCache using the same file, but
+	// only for the same version of the file.
+	rc = open()
+	defer rc.Release()
+	if !rc.lookup(file.key(0), buf) {
+		t.Fatalf("lookup of cached block failed")
+	}
+	if !bytes.Equal(buf, want) {
+		t.Errorf("lookup returned wrong contents")
+	}
+	if rc.lookup(file.key(1), buf) {
+		t.Errorf("lookup of another block succeeded")
+	}
+	file.mtime++
+	if rc.lookup(file.key(0), buf) {
+		t.Errorf("lookup of modified file succeeded")
+	}
+}
+
+func TestMaxFillRange(t *testing.T) {
+	fs := filesystem{
+		opts: filesystemOptions{
+			readahead: 4 * usermem.PageSize,
+		},
+	}
+	for _, test := range []struct {
+		name     string
+		required memmap.MappableRange
+		optional memmap.MappableRange
+		want     memmap.MappableRange
+	}{
+		{
+			name:     "optional within readahead",
+			required: memmap.MappableRange{0, usermem.PageSize},
+			optional: memmap.MappableRange{0, 2 * usermem.PageSize},
+			want:     memmap.MappableRange{0, 2 * usermem.PageSize},
+		},
+		{
+			name:     "optional beyond readahead",
+			required: memmap.MappableRange{usermem.PageSize, 2 * usermem.PageSize},
+			optional: memmap.MappableRange{0, 16 * usermem.PageSize},
+			want:     memmap.MappableRange{usermem.PageSize, 5 * usermem.PageSize},
+		},
+		{
+			name:     "required beyond readahead",
+			required: memmap.MappableRange{0, 8 * usermem.PageSize},
+			optional: memmap.MappableRange{0, 16 * usermem.PageSize},
+			want:     memmap.MappableRange{0, 8 * usermem.PageSize},
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			if got := fs.maxFillRange(test.required, test.optional); got != test.want {
+				t.Errorf("maxFillRange(%v, %v): got %v, want %v", test.required, test.optional, got, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,165 @@
+// Copyright 2021 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gofer
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"gvisor.dev/gvisor/pkg/p9"
+	"gvisor.dev/gvisor/pkg/sentry/kernel/auth"
+	"gvisor.dev/gvisor/pkg/syserror"
+)
+
+// idMap maps the UIDs or GIDs of files in an ID-mapped mount to the IDs
+// reported by the remote filesystem. Each entry maps a range of IDs in the
+// mount (FirstID) to an equally-sized range of remote IDs (FirstParentID).
+// A nil idMap maps all IDs to themselves.
+//
+// +stateify savable
+type idMap []auth.IDMapEntry
+
+// parseIDMap parses the value of the uidmap and gidmap mount options, which
+// is a space-separated list of entries of the form "id:remoteid:length".
+func parseIDMap(str string) (idMap, error) {
+	var m idMap
+	for _, entry := range strings.Fields(str) {
+		fields := strings.Split(entry, ":")
+		if len(fields) != 3 {
+			return nil, fmt.Errorf("invalid entry %q", entry)
+		}
+		var ids [3]uint32
+		for i, field := range fields {
+			id, err := strconv.ParseUint(field, 10, 32)
+			if err != nil {
+				return nil, fmt.Errorf("invalid entry %q: %v", entry, err)
+			}
+			ids[i] = uint32(id)
+		}
+		e := auth.IDMapEntry{
+			FirstID:       ids[0],
+			FirstParentID: ids[1],
+			Length:        ids[2],
+		}
+		if e.Length == 0 || uint64(e.FirstID)+uint64(e.Length) > math.MaxUint32 || uint64(e.FirstParentID)+uint64(e.Length) > math.MaxUint32 {
+			return nil, fmt.Errorf("invalid entry %q: range out of bounds", entry)
+		}
+		for _, other := range m {
+			if overlaps(e.FirstID, other.FirstID, e.Length, other.Length) || overlaps(e.FirstParentID, other.FirstParentID, e.Length, other.Length) {
+				return nil, fmt.Errorf("entry %q overlaps another entry", entry)
+			}
+		}
+		m = append(m, e)
+	}
+	if len(m) == 0 {
+		return nil, fmt.Errorf("no entries")
+	}
+	return m, nil
+}
+
+// overlaps returns true if the ranges [a, a+alen) and [b, b+blen) overlap.
+func overlaps(a, b, alen, blen uint32) bool {
+	return uint64(a) < uint64(b)+uint64(blen) && uint64(b) < uint64(a)+uint64(alen)
+}
+
+// toRemote returns the remote ID that id maps to. ok is false if id is
+// unmapped.
+func (m idMap) toRemote(id uint32) (remote uint32, ok bool) {
+	if m == nil {
+		return id, true
+	}
+	for _, e := range m {
+		if id >= e.FirstID && id-e.FirstID < e.Length {
+			return e.FirstParentID + (id - e.FirstID), true
+		}
+	}
+	return 0, false
+}
+
+// fromRemote returns the ID that the remote ID remote maps to. ok is false if
+// remote is unmapped.
+func (m idMap) fromRemote(remote uint32) (id uint32, ok bool) {
+	if m == nil {
+		return remote, true
+	}
+	for _, e := range m {
+		if remote >= e.FirstParentID && remote-e.FirstParentID < e.Length {
+			return e.FirstID + (remote - e.FirstParentID), true
+		}
+	}
+	return 0, false
+}
+
+// dentryUIDFromP9UID returns the UID of a file whose owner is uid in the
+// remote filesystem. Unmapped UIDs are reported as the overflow UID, as in
+// Linux.
+func (fs *filesystem) dentryUIDFromP9UID(uid p9.UID) uint32 {
+	if !uid.Ok() {
+		return uint32(auth.OverflowUID)
+	}
+	kuid, ok := fs.opts.uidMap.fromRemote(uint32(uid))
+	if !ok {
+		return uint32(auth.OverflowUID)
+	}
+	return kuid
+}
+
+// dentryGIDFromP9GID is the equivalent of dentryUIDFromP9UID for GIDs.
+func (fs *filesystem) dentryGIDFromP9GID(gid p9.GID) uint32 {
+	if !gid.Ok() {
+		return uint32(auth.OverflowGID)
+	}
+	kgid, ok := fs.opts.gidMap.fromRemote(uint32(gid))
+	if !ok {
+		return uint32(auth.OverflowGID)
+	}
+	return kgid
+}
+
+// p9UID returns the UID in the remote filesystem of files owned by kuid. If
+// kuid is unmapped, it returns EOVERFLOW, as Linux does for files created or
+// chowned in ID-mapped mounts.
+func (fs *filesystem) p9UID(kuid auth.KUID) (p9.UID, error) {
+	uid, ok := fs.opts.uidMap.toRemote(uint32(kuid))
+	if !ok {
+		return p9.NoUID, syserror.EOVERFLOW
+	}
+	return p9.UID(uid), nil
+}
+
+// p9GID is the equivalent of p9UID for GIDs.
+func (fs *filesystem) p9GID(kgid auth.KGID) (p9.GID, error) {
+	gid, ok := fs.opts.gidMap.toRemote(uint32(kgid))
+	if !ok {
+		return p9.NoGID, syserror.EOVERFLOW
+	}
+	return p9.GID(gid), nil
+}
+
+// p9Owner returns the UID and GID in the remote filesystem of files created
+// by creds.
+func (fs *filesystem) p9Owner(creds *auth.Credentials) (p9.UID, p9.GID, error) {
+	uid, err := fs.p9UID(creds.EffectiveKUID)
+	if err != nil {
+		return 0, 0, err
+	}
+	gid, err := fs.p9GID(creds.EffectiveKGID)
+	if err != nil {
+		return 0, 0, err
+	}
+	return uid, gid, nil
+}
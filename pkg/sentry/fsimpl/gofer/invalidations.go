@@ -0,0 +1,250 @@
+// Copyright 2021 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gofer
+
+import (
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"gvisor.dev/gvisor/pkg/abi/linux"
+	"gvisor.dev/gvisor/pkg/context"
+	"gvisor.dev/gvisor/pkg/log"
+	"gvisor.dev/gvisor/pkg/p9"
+	"gvisor.dev/gvisor/pkg/sentry/vfs"
+)
+
+// In InteropModeShared, cached metadata must normally be revalidated before
+// use, which costs a round trip to the server for each stat(2) or path
+// component lookup. If the server reports the changes made to files
+// ("invalidations"), revalidation is skipped for dentries whose metadata
+// hasn't been invalidated since it was last read from the server:
+//
+// - dentry.metadataGen is incremented when the remote file may have changed.
+// filesystem.invalidationEpoch is incremented when all cached metadata is
+// invalidated, e.g. when invalidations are lost.
+//
+// - Before reading metadata from the server, the sum of the two plus one is
+// recorded, and stored in dentry.validGen once metadata is updated. Metadata
+// is valid as long as the sum doesn't change, and both counters only increase.
+//
+// - Invalidations of directory entries are applied with the directory's dirMu
+// locked, which serializes them with lookups of the entry. This allows
+// caching negative lookups as well.
+//
+// Changes made by the client are invalidated when they complete, rather than
+// when the server reports them, for coherence with the client's own
+// operations.
+//
+// Invalidations carry the inotify events describing the change, which are
+// delivered to the inotify watches of the affected dentries, so that changes
+// made by other clients of a shared mount are observable with inotify. The
+// server can't tell which changes were made by this client, whose inotify
+// events were already generated locally; events are dropped if the affected
+// dentries had local events in the last remoteEventEchoWindow instead. Thus,
+// changes made by other clients at the same time as local changes may not be
+// reported.
+
+// invalidationsTimeout is the maximum time to wait for invalidations in each
+// request to the server.
+const invalidationsTimeout = 10 * time.Second
+
+// remoteEventEchoWindow is the time after a local inotify event during which
+// inotify events reported by the server for the same dentry are assumed to
+// echo it.
+const remoteEventEchoWindow = 500 * time.Millisecond
+
+// remoteEventMask is the mask of the inotify events reported by the server
+// that are delivered to local watches.
+const remoteEventMask = linux.IN_ALL_EVENTS | linux.IN_ISDIR
+
+// remoteInodeEventMask is the mask of the inotify events reported by the
+// server for directory entries that are also delivered to the watches of the
+// entry's dentry.
+const remoteInodeEventMask = linux.IN_ATTRIB | linux.IN_CLOSE_WRITE | linux.IN_MODIFY
+
+// watchInvalidations applies the invalidations reported by the server until fs
+// is released.
+func (fs *filesystem) watchInvalidations() {
+	var seq uint64
+	for atomic.LoadInt32(&fs.released) == 0 {
+		invs, next, overflow, err := fs.client.WaitInvalidations(seq, invalidationsTimeout)
+		if atomic.LoadInt32(&fs.released) != 0 {
+			return
+		}
+		if err != nil {
+			if err == syscall.ENOSYS {
+				log.Infof("gofer.filesystem.watchInvalidations: server doesn't report invalidations, revalidating cached metadata instead")
+			} else {
+				log.Warningf("gofer.filesystem.watchInvalidations: revalidating cached metadata: %v", err)
+			}
+			fs.stopInvalidations()
+			return
+		}
+		if overflow {
+			// Invalidations since seq are lost, including all invalidations
+			// if this is the first request.
+			fs.invalidateAll()
+			atomic.StoreInt32(&fs.invalidating, 1)
+		}
+		for _, inv := range invs {
+			fs.applyInvalidation(inv)
+		}
+		seq = next
+	}
+}
+
+// stopInvalidations stops trusting cached metadata.
+func (fs *filesystem) stopInvalidations() {
+	atomic.StoreInt32(&fs.invalidating, 0)
+	fs.invalidateAll()
+}
+
+// invalidateAll invalidates the cached metadata of all dentries.
+func (fs *filesystem) invalidateAll() {
+	atomic.AddUint64(&fs.invalidationEpoch, 1)
+
+	// Drop negative lookups, which aren't tracked by generation.
+	fs.syncMu.Lock()
+	ds := make([]*dentry, 0, len(fs.syncableDentries))
+	for d := range fs.syncableDentries {
+		ds = append(ds, d)
+	}
+	fs.syncMu.Unlock()
+	for _, d := range ds {
+		d.dirMu.Lock()
+		for name, child := range d.children {
+			if child == nil {
+				delete(d.children, name)
+			}
+		}
+		d.dirMu.Unlock()
+	}
+}
+
+// applyInvalidation invalidates the cached metadata of the dentries affected
+// by inv, and delivers its inotify events.
+func (fs *filesystem) applyInvalidation(inv p9.Invalidation) {
+	ctx := context.Background()
+	events := inv.Mask & remoteEventMask
+	now := time.Now().UnixNano()
+
+	fs.syncMu.Lock()
+	ds := make([]*dentry, 0, len(fs.dentriesByQIDPath[inv.QIDPath]))
+	for d := range fs.dentriesByQIDPath[inv.QIDPath] {
+		ds = append(ds, d)
+	}
+	fs.syncMu.Unlock()
+
+	for _, d := range ds {
+		// Changes to entries, e.g. their creation, also change the
+		// directory's metadata.
+		d.invalidateMetadata()
+		if inv.Name == "" || !d.isDir() {
+			// Files are reported changes through their parent, except
+			// the root.
+			if events != 0 && d == fs.root && !d.echoesLocalEvent(now) {
+				d.watches.Notify(ctx, "", events, inv.Cookie, vfs.InodeEvent, false /* unlinked */)
+			}
+			continue
+		}
+		d.dirMu.Lock()
+		child, ok := d.children[inv.Name]
+		if ok {
+			if child == nil {
+				delete(d.children, inv.Name)
+			} else {
+				child.invalidateMetadata()
+			}
+		}
+		if events != 0 && !d.echoesLocalEvent(now) && (child == nil || !child.echoesLocalEvent(now)) {
+			d.watches.Notify(ctx, inv.Name, events, inv.Cookie, vfs.InodeEvent, false /* unlinked */)
+			if child != nil && events&remoteInodeEventMask != 0 {
+				child.watches.Notify(ctx, "", events, inv.Cookie, vfs.InodeEvent, false /* unlinked */)
+			}
+		}
+		d.dirMu.Unlock()
+	}
+}
+
+// noteLocalEvent records that an inotify event was generated locally for d.
+func (d *dentry) noteLocalEvent() {
+	atomic.StoreInt64(&d.lastLocalEvent, time.Now().UnixNano())
+}
+
+// echoesLocalEvent returns true if inotify events reported by the server for
+// d at time now may echo events generated locally.
+func (d *dentry) echoesLocalEvent(now int64) bool {
+	return now-atomic.LoadInt64(&d.lastLocalEvent) < int64(remoteEventEchoWindow)
+}
+
+// addDentryQIDPathLocked adds d to fs.dentriesByQIDPath.
+//
+// Preconditions: fs.syncMu must be locked.
+func (fs *filesystem) addDentryQIDPathLocked(d *dentry) {
+	if fs.dentriesByQIDPath == nil {
+		return
+	}
+	ds := fs.dentriesByQIDPath[d.qidPath]
+	if ds == nil {
+		ds = make(map[*dentry]struct{})
+		fs.dentriesByQIDPath[d.qidPath] = ds
+	}
+	ds[d] = struct{}{}
+}
+
+// removeDentryQIDPathLocked removes d from fs.dentriesByQIDPath.
+//
+// Preconditions: fs.syncMu must be locked.
+func (fs *filesystem) removeDentryQIDPathLocked(d *dentry) {
+	if fs.dentriesByQIDPath == nil {
+		return
+	}
+	ds := fs.dentriesByQIDPath[d.qidPath]
+	delete(ds, d)
+	if len(ds) == 0 {
+		delete(fs.dentriesByQIDPath, d.qidPath)
+	}
+}
+
+// invalidatingMetadata returns true if cached metadata is trusted until the
+// server invalidates it.
+func (fs *filesystem) invalidatingMetadata() bool {
+	return atomic.LoadInt32(&fs.invalidating) != 0
+}
+
+// metadataGeneration returns the generation of d's metadata, to be passed to
+// d.setMetadataValid() once metadata read from the server after this call is
+// cached.
+func (d *dentry) metadataGeneration() uint64 {
+	return atomic.LoadUint64(&d.fs.invalidationEpoch) + atomic.LoadUint64(&d.metadataGen) + 1
+}
+
+// setMetadataValid marks d's cached metadata valid, unless it was invalidated
+// since gen was returned by d.metadataGeneration().
+func (d *dentry) setMetadataValid(gen uint64) {
+	atomic.StoreUint64(&d.validGen, gen)
+}
+
+// metadataValid returns true if d's cached metadata is up to date, without
+// revalidating it.
+func (d *dentry) metadataValid() bool {
+	return d.fs.invalidatingMetadata() && atomic.LoadUint64(&d.validGen) == d.metadataGeneration()
+}
+
+// invalidateMetadata marks d's cached metadata as possibly stale.
+func (d *dentry) invalidateMetadata() {
+	atomic.AddUint64(&d.metadataGen, 1)
+}
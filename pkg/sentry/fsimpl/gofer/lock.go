@@ -0,0 +1,244 @@
+// Copyright 2021 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gofer
+
+import (
+	"sync/atomic"
+	"time"
+
+	"gvisor.dev/gvisor/pkg/abi/linux"
+	"gvisor.dev/gvisor/pkg/context"
+	"gvisor.dev/gvisor/pkg/log"
+	"gvisor.dev/gvisor/pkg/p9"
+	fslock "gvisor.dev/gvisor/pkg/sentry/fs/lock"
+	"gvisor.dev/gvisor/pkg/syserror"
+)
+
+// With remote locking, which is the default in InteropModeShared, POSIX record
+// locks and BSD locks are also taken on the remote file, so that they are
+// coherent with the locks of other clients sharing it, such as the containers
+// of other sandboxes, and of host processes. Each lock owner holds its remote
+// locks through a fid of its own, which the server locks with open file
+// description locks or flock(2), so that the locks of distinct owners
+// conflict.
+//
+// Remote locks are taken before local locks. Since the locks of all local
+// owners are held remotely as well, the local lock only fails to be taken if
+// the server lost the remote lock.
+//
+// The server doesn't wait for conflicting locks to be released, so blocking
+// lock requests poll the server instead.
+
+// remoteLockPollInterval is the interval at which blocking lock requests retry
+// to take a remote lock.
+const remoteLockPollInterval = 100 * time.Millisecond
+
+// remoteLocking returns true if locks are taken on remote files.
+func (fs *filesystem) remoteLocking() bool {
+	return fs.opts.remoteLocks && atomic.LoadInt32(&fs.remoteLocksUnsupported) == 0
+}
+
+// remoteLocksNotSupported stops taking remote locks after the server failed to
+// take one with err.
+func (fs *filesystem) remoteLocksNotSupported(err error) {
+	if atomic.CompareAndSwapInt32(&fs.remoteLocksUnsupported, 0, 1) {
+		log.Infof("gofer.filesystem: server doesn't support remote locks, locking files locally only: %v", err)
+	}
+}
+
+// p9LockRange returns the start and length of r in the style of Tlock.
+func p9LockRange(r fslock.LockRange) (uint64, uint64) {
+	if r.End == fslock.LockEOF {
+		return r.Start, 0
+	}
+	return r.Start, r.End - r.Start
+}
+
+// remoteLockHandleLocked returns the handle through which uid holds remote
+// locks on d, opening it if needed. read and write are the access modes of
+// the FD through which uid takes a lock.
+//
+// Preconditions: d.remoteLocksMu must be locked.
+func (d *dentry) remoteLockHandleLocked(ctx context.Context, uid fslock.UniqueID, read, write bool) (handle, error) {
+	if h, ok := d.remoteLocks[uid]; ok {
+		return h, nil
+	}
+	// Open the fid for both reading and writing if possible, so that it can
+	// hold both kinds of locks for FDs with either access mode.
+	h, err := openHandle(ctx, d.file, true /* read */, true /* write */, false /* trunc */)
+	if err != nil && !(read && write) {
+		h, err = openHandle(ctx, d.file, read, write, false /* trunc */)
+	}
+	if err != nil {
+		return handle{fd: -1}, err
+	}
+	if d.remoteLocks == nil {
+		d.remoteLocks = make(map[fslock.UniqueID]handle)
+	}
+	d.remoteLocks[uid] = h
+	return h, nil
+}
+
+// lockRemote takes a lock of type t on the range r of d's remote file on
+// behalf of uid. If block is nil, lockRemote fails with ErrWouldBlock if a
+// conflicting lock is held; otherwise, it waits for the lock to be released.
+func (d *dentry) lockRemote(ctx context.Context, uid fslock.UniqueID, t fslock.LockType, r fslock.LockRange, block fslock.Blocker, read, write bool) error {
+	typ := p9.LockTypeReadLock
+	if t == fslock.WriteLock {
+		typ = p9.LockTypeWriteLock
+	}
+	start, length := p9LockRange(r)
+	return d.retryRemoteLock(ctx, uid, block, read, write, func(h handle) (p9.LockStatus, error) {
+		return h.file.lock(ctx, typ, start, length)
+	})
+}
+
+// lockRemoteBSD takes a BSD lock of type t on d's remote file on behalf of
+// uid. If block is nil, lockRemoteBSD fails with ErrWouldBlock if a
+// conflicting lock is held; otherwise, it waits for the lock to be released.
+//
+// As with flock(2) on Linux, a lock held by uid that fails to be converted to
+// another type may be released remotely.
+func (d *dentry) lockRemoteBSD(ctx context.Context, uid fslock.UniqueID, t fslock.LockType, block fslock.Blocker, read, write bool) error {
+	typ := p9.LockTypeReadLock
+	if t == fslock.WriteLock {
+		typ = p9.LockTypeWriteLock
+	}
+	return d.retryRemoteLock(ctx, uid, block, read, write, func(h handle) (p9.LockStatus, error) {
+		return h.file.flock(ctx, typ)
+	})
+}
+
+// retryRemoteLock calls lock with the handle through which uid holds remote
+// locks on d until it takes the lock. If block is nil, retryRemoteLock fails
+// with ErrWouldBlock instead of retrying.
+func (d *dentry) retryRemoteLock(ctx context.Context, uid fslock.UniqueID, block fslock.Blocker, read, write bool, lock func(h handle) (p9.LockStatus, error)) error {
+	for {
+		d.remoteLocksMu.Lock()
+		h, err := d.remoteLockHandleLocked(ctx, uid, read, write)
+		if err != nil {
+			d.remoteLocksMu.Unlock()
+			return err
+		}
+		status, err := lock(h)
+		d.remoteLocksMu.Unlock()
+		if err != nil {
+			return err
+		}
+		switch status {
+		case p9.LockStatusOK:
+			return nil
+		case p9.LockStatusBlocked, p9.LockStatusGrace:
+		default:
+			return syserror.EIO
+		}
+
+		if block == nil {
+			return syserror.ErrWouldBlock
+		}
+		ch := make(chan struct{})
+		timer := time.AfterFunc(remoteLockPollInterval, func() { close(ch) }) // S/R-SAFE: the lock request is retried after restore.
+		err = block.Block(ch)
+		timer.Stop()
+		if err != nil {
+			return syserror.ERESTARTSYS
+		}
+	}
+}
+
+// unlockRemote releases the locks held by uid on the range r of d's remote
+// file. Unlocking the whole file closes the fid through which they were
+// held.
+func (d *dentry) unlockRemote(ctx context.Context, uid fslock.UniqueID, r fslock.LockRange) error {
+	d.remoteLocksMu.Lock()
+	defer d.remoteLocksMu.Unlock()
+	h, ok := d.remoteLocks[uid]
+	if !ok {
+		return nil
+	}
+	if r.Start == 0 && r.End == fslock.LockEOF {
+		// Closing the fid releases its locks.
+		delete(d.remoteLocks, uid)
+		h.close(ctx)
+		return nil
+	}
+	start, length := p9LockRange(r)
+	_, err := h.file.lock(ctx, p9.LockTypeUnlock, start, length)
+	return err
+}
+
+// unlockRemoteBSD releases the BSD lock held by uid on d's remote file, by
+// closing the fid through which it was held.
+func (d *dentry) unlockRemoteBSD(ctx context.Context, uid fslock.UniqueID) {
+	d.remoteLocksMu.Lock()
+	defer d.remoteLocksMu.Unlock()
+	if h, ok := d.remoteLocks[uid]; ok {
+		delete(d.remoteLocks, uid)
+		h.close(ctx)
+	}
+}
+
+// testRemote returns a lock held on d's remote file that conflicts with a lock
+// of type t on the range r taken by uid, in the style of F_GETLK.
+func (d *dentry) testRemote(ctx context.Context, uid fslock.UniqueID, t fslock.LockType, r fslock.LockRange, read, write bool) (linux.Flock, error) {
+	typ := p9.LockTypeReadLock
+	if t == fslock.WriteLock {
+		typ = p9.LockTypeWriteLock
+	}
+	start, length := p9LockRange(r)
+
+	d.remoteLocksMu.Lock()
+	defer d.remoteLocksMu.Unlock()
+	h, ok := d.remoteLocks[uid]
+	if !ok {
+		// Test through a temporary fid, which holds no locks.
+		var err error
+		if h, err = openHandle(ctx, d.file, read, write, false /* trunc */); err != nil {
+			return linux.Flock{}, err
+		}
+		defer h.close(ctx)
+	}
+	typ, start, length, err := h.file.getLock(ctx, typ, start, length)
+	if err != nil {
+		return linux.Flock{}, err
+	}
+	f := linux.Flock{
+		Type:  linux.F_UNLCK,
+		Start: int64(start),
+		Len:   int64(length),
+		// The owner of remote locks is unknown, as for open file description
+		// locks.
+		PID: -1,
+	}
+	switch typ {
+	case p9.LockTypeReadLock:
+		f.Type = linux.F_RDLCK
+	case p9.LockTypeWriteLock:
+		f.Type = linux.F_WRLCK
+	default:
+		return linux.Flock{Type: linux.F_UNLCK}, nil
+	}
+	return f, nil
+}
+
+// releaseRemoteLocksLocked closes the fids holding remote locks on d.
+//
+// Preconditions: d.remoteLocksMu must be locked.
+func (d *dentry) releaseRemoteLocksLocked(ctx context.Context) {
+	for uid, h := range d.remoteLocks {
+		h.close(ctx)
+		delete(d.remoteLocks, uid)
+	}
+}
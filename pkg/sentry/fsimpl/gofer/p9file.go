@@ -169,6 +169,28 @@ func (f p9file) create(ctx context.Context, name string, flags p9.OpenFlags, per
 	return fdobj, p9file{newfile}, qid, iounit, err
 }
 
+func (f p9file) createTmpfile(ctx context.Context, flags p9.OpenFlags, permissions p9.FileMode, uid p9.UID, gid p9.GID) (*fd.FD, p9file, p9.QID, uint32, error) {
+	creator, ok := f.file.(p9.TmpfileCreator)
+	if !ok {
+		return nil, p9file{}, p9.QID{}, 0, syserror.ENOSYS
+	}
+	ctx.UninterruptibleSleepStart(false)
+	fdobj, newfile, qid, iounit, err := creator.CreateTmpfile(flags, permissions, uid, gid)
+	ctx.UninterruptibleSleepFinish(false)
+	return fdobj, p9file{newfile}, qid, iounit, err
+}
+
+func (f p9file) bind(ctx context.Context, sockType uint32, name string, permissions p9.FileMode, uid p9.UID, gid p9.GID) (*fd.FD, p9.QID, error) {
+	binder, ok := f.file.(p9.Binder)
+	if !ok {
+		return nil, p9.QID{}, syserror.ENOSYS
+	}
+	ctx.UninterruptibleSleepStart(false)
+	fdobj, qid, err := binder.Bind(sockType, name, permissions, uid, gid)
+	ctx.UninterruptibleSleepFinish(false)
+	return fdobj, qid, err
+}
+
 func (f p9file) mkdir(ctx context.Context, name string, permissions p9.FileMode, uid p9.UID, gid p9.GID) (p9.QID, error) {
 	ctx.UninterruptibleSleepStart(false)
 	qid, err := f.file.Mkdir(name, permissions, uid, gid)
@@ -204,6 +226,17 @@ func (f p9file) rename(ctx context.Context, newDir p9file, newName string) error
 	return err
 }
 
+func (f p9file) renameAtFlags(ctx context.Context, oldName string, newDir p9file, newName string, flags uint32) error {
+	renamer, ok := f.file.(p9.FlagRenamer)
+	if !ok {
+		return syserror.ENOSYS
+	}
+	ctx.UninterruptibleSleepStart(false)
+	err := renamer.RenameAtFlags(oldName, newDir.file, newName, flags)
+	ctx.UninterruptibleSleepFinish(false)
+	return err
+}
+
 func (f p9file) unlinkAt(ctx context.Context, name string, flags uint32) error {
 	ctx.UninterruptibleSleepStart(false)
 	err := f.file.UnlinkAt(name, flags)
@@ -238,3 +271,36 @@ func (f p9file) connect(ctx context.Context, flags p9.ConnectFlags) (*fd.FD, err
 	ctx.UninterruptibleSleepFinish(false)
 	return fdobj, err
 }
+
+func (f p9file) lock(ctx context.Context, typ p9.LockType, start, length uint64) (p9.LockStatus, error) {
+	locker, ok := f.file.(p9.Locker)
+	if !ok {
+		return p9.LockStatusError, syserror.ENOSYS
+	}
+	ctx.UninterruptibleSleepStart(false)
+	status, err := locker.Lock(typ, start, length)
+	ctx.UninterruptibleSleepFinish(false)
+	return status, err
+}
+
+func (f p9file) getLock(ctx context.Context, typ p9.LockType, start, length uint64) (p9.LockType, uint64, uint64, error) {
+	locker, ok := f.file.(p9.Locker)
+	if !ok {
+		return p9.LockTypeUnlock, 0, 0, syserror.ENOSYS
+	}
+	ctx.UninterruptibleSleepStart(false)
+	typ, start, length, err := locker.GetLock(typ, start, length)
+	ctx.UninterruptibleSleepFinish(false)
+	return typ, start, length, err
+}
+
+func (f p9file) flock(ctx context.Context, typ p9.LockType) (p9.LockStatus, error) {
+	locker, ok := f.file.(p9.Locker)
+	if !ok {
+		return p9.LockStatusError, syserror.ENOSYS
+	}
+	ctx.UninterruptibleSleepStart(false)
+	status, err := locker.Flock(typ)
+	ctx.UninterruptibleSleepFinish(false)
+	return status, err
+}
@@ -0,0 +1,421 @@
+// Copyright 2021 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gofer
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"sync/atomic"
+
+	"golang.org/x/sys/unix"
+	"gvisor.dev/gvisor/pkg/context"
+	"gvisor.dev/gvisor/pkg/fd"
+	"gvisor.dev/gvisor/pkg/log"
+	"gvisor.dev/gvisor/pkg/rand"
+	"gvisor.dev/gvisor/pkg/safemem"
+	"gvisor.dev/gvisor/pkg/sync"
+	"gvisor.dev/gvisor/pkg/usermem"
+)
+
+// Layout of read cache files.
+//
+// The file starts with a header, followed by the block table and the key
+// table, and then by the blocks themselves. Each block holds
+// readCacheBlockSize bytes of remote file contents, and is addressed by the
+// SHA-256 digest of its contents, which the block table records for each
+// block slot. The key table maps keys, each identifying a block-aligned range
+// of a particular version of a remote file, to the digests of their contents.
+// There are more key slots than block slots, so that identical blocks of
+// different files share a block slot.
+//
+// Both tables are replaced in FIFO order, and are only held in memory
+// otherwise, so the file can be shared by concurrent sandboxes with no further
+// coordination: blocks are verified against their digest when read, so a block
+// that was torn or replaced by another sandbox is only a cache miss.
+const (
+	readCacheMagic      = 0x3168636143527667 // "gvRCach1"
+	readCacheHeaderSize = usermem.PageSize
+	readCacheBlockSize  = 64 << 10
+
+	// readCacheHeaderLen is the number of bytes of the header that are used:
+	// magic, number of blocks, block size and header CRC.
+	readCacheHeaderLen = 20
+
+	readCacheDigestSize   = sha256.Size
+	readCacheKeyEntrySize = 2 * readCacheDigestSize
+	readCacheKeysPerBlock = 2
+)
+
+var readCacheCRCTable = crc32.MakeTable(crc32.Castagnoli)
+
+// readCacheDigest is a SHA-256 digest. The zero digest marks unused slots.
+type readCacheDigest [readCacheDigestSize]byte
+
+// readCacheKeyEntry is an entry in the key table.
+type readCacheKeyEntry struct {
+	key    readCacheDigest
+	digest readCacheDigest
+}
+
+// ReadCache is a persistent cache of remote file contents in a host file. It
+// is used by gofer mounts that the sandbox doesn't write to, so that
+// sandboxes started repeatedly on the same host serve the files of their
+// images from local storage rather than from the remote filesystem. Only
+// files for which the gofer doesn't donate host FDs are cached, since files
+// with host FDs are read from the host directly.
+//
+// Cached contents are keyed by the file's QID path, size, modification time
+// and change time, so changes to remote files invalidate their cached
+// contents.
+type ReadCache struct {
+	// file is the host file. file is immutable.
+	file *fd.FD
+
+	// numBlocks is the number of block slots in the file. The offsets are
+	// those of the block table, key table and the first block. These fields
+	// are immutable.
+	numBlocks     uint32
+	blockTableOff int64
+	keyTableOff   int64
+	dataOff       int64
+
+	// mu protects the following fields and serializes writes to file.
+	mu sync.Mutex
+
+	// blocks maps digests to the block slots holding them, and blockDigests
+	// is the digest of each block slot.
+	blocks       map[readCacheDigest]uint32
+	blockDigests []readCacheDigest
+
+	// keys maps keys to their key slots, and keyEntries is the entry in each
+	// key slot.
+	keys       map[readCacheDigest]uint32
+	keyEntries []readCacheKeyEntry
+
+	// nextBlock and nextKey are the slots that are replaced next.
+	nextBlock uint32
+	nextKey   uint32
+
+	// hits and misses count cache lookups. They are accessed using atomic
+	// memory operations.
+	hits   uint64
+	misses uint64
+
+	// bufs holds block-sized buffers.
+	bufs sync.Pool
+}
+
+// NewReadCache returns a ReadCache that stores cached contents in the given
+// host file, whose size determines the capacity of the cache. Contents cached
+// in the file by previous ReadCaches with the same capacity are reused. The
+// ReadCache takes ownership of file.
+func NewReadCache(file *fd.FD) (*ReadCache, error) {
+	var stat unix.Stat_t
+	if err := unix.Fstat(file.FD(), &stat); err != nil {
+		return nil, err
+	}
+	perBlock := int64(readCacheDigestSize + readCacheKeysPerBlock*readCacheKeyEntrySize + readCacheBlockSize)
+	// Reserve a page for the alignment of the first block.
+	numBlocks := (stat.Size - readCacheHeaderSize - usermem.PageSize) / perBlock
+	if numBlocks <= 0 {
+		return nil, fmt.Errorf("read cache file is too small (%d bytes)", stat.Size)
+	}
+	if numBlocks > 1<<30 {
+		numBlocks = 1 << 30
+	}
+	rc := &ReadCache{
+		file:          file,
+		numBlocks:     uint32(numBlocks),
+		blockTableOff: readCacheHeaderSize,
+		blocks:        make(map[readCacheDigest]uint32),
+		blockDigests:  make([]readCacheDigest, numBlocks),
+		keys:          make(map[readCacheDigest]uint32),
+		keyEntries:    make([]readCacheKeyEntry, numBlocks*readCacheKeysPerBlock),
+		bufs: sync.Pool{
+			New: func() interface{} {
+				return make([]byte, readCacheBlockSize)
+			},
+		},
+	}
+	rc.keyTableOff = rc.blockTableOff + numBlocks*readCacheDigestSize
+	rc.dataOff = rc.keyTableOff + numBlocks*readCacheKeysPerBlock*readCacheKeyEntrySize
+	if rem := rc.dataOff % usermem.PageSize; rem != 0 {
+		rc.dataOff += usermem.PageSize - rem
+	}
+
+	// Start replacing slots at a random position, so that sandboxes sharing
+	// the file are unlikely to replace each other's blocks.
+	var r [8]byte
+	rand.Read(r[:])
+	rc.nextBlock = binary.LittleEndian.Uint32(r[0:]) % rc.numBlocks
+	rc.nextKey = binary.LittleEndian.Uint32(r[4:]) % uint32(len(rc.keyEntries))
+
+	if ok, err := rc.load(); err != nil {
+		return nil, err
+	} else if !ok {
+		log.Infof("Initializing gofer read cache with %d blocks", rc.numBlocks)
+		if err := rc.reset(); err != nil {
+			return nil, err
+		}
+	} else {
+		log.Infof("Loaded gofer read cache with %d of %d blocks in use", len(rc.blocks), rc.numBlocks)
+	}
+	return rc, nil
+}
+
+// load reads the tables of the file into rc. It returns false if the file
+// doesn't hold a cache with the same capacity.
+func (rc *ReadCache) load() (bool, error) {
+	hdr := make([]byte, readCacheHeaderLen)
+	if _, err := rc.file.ReadAt(hdr, 0); err != nil {
+		if err == io.EOF {
+			return false, nil
+		}
+		return false, err
+	}
+	le := binary.LittleEndian
+	if le.Uint64(hdr[0:]) != readCacheMagic || le.Uint32(hdr[16:]) != crc32.Checksum(hdr[:16], readCacheCRCTable) {
+		return false, nil
+	}
+	if le.Uint32(hdr[8:]) != rc.numBlocks || le.Uint32(hdr[12:]) != readCacheBlockSize {
+		return false, nil
+	}
+
+	buf := make([]byte, rc.dataOff-rc.blockTableOff)
+	if _, err := rc.file.ReadAt(buf, rc.blockTableOff); err != nil {
+		return false, err
+	}
+	for i := range rc.blockDigests {
+		copy(rc.blockDigests[i][:], buf[i*readCacheDigestSize:])
+		if d := rc.blockDigests[i]; d != (readCacheDigest{}) {
+			rc.blocks[d] = uint32(i)
+		}
+	}
+	buf = buf[rc.keyTableOff-rc.blockTableOff:]
+	for i := range rc.keyEntries {
+		e := &rc.keyEntries[i]
+		copy(e.key[:], buf[i*readCacheKeyEntrySize:])
+		copy(e.digest[:], buf[i*readCacheKeyEntrySize+readCacheDigestSize:])
+		if e.key != (readCacheDigest{}) {
+			rc.keys[e.key] = uint32(i)
+		}
+	}
+	return true, nil
+}
+
+// reset clears the tables of the file and writes its header.
+func (rc *ReadCache) reset() error {
+	zeroes := make([]byte, readCacheBlockSize)
+	for off := rc.blockTableOff; off < rc.dataOff; off += int64(len(zeroes)) {
+		n := rc.dataOff - off
+		if n > int64(len(zeroes)) {
+			n = int64(len(zeroes))
+		}
+		if _, err := rc.file.WriteAt(zeroes[:n], off); err != nil {
+			return err
+		}
+	}
+	hdr := make([]byte, readCacheHeaderLen)
+	le := binary.LittleEndian
+	le.PutUint64(hdr[0:], readCacheMagic)
+	le.PutUint32(hdr[8:], rc.numBlocks)
+	le.PutUint32(hdr[12:], readCacheBlockSize)
+	le.PutUint32(hdr[16:], crc32.Checksum(hdr[:16], readCacheCRCTable))
+	_, err := rc.file.WriteAt(hdr, 0)
+	return err
+}
+
+// Release closes the host file.
+func (rc *ReadCache) Release() {
+	log.Infof("Releasing gofer read cache: %d hits, %d misses", atomic.LoadUint64(&rc.hits), atomic.LoadUint64(&rc.misses))
+	rc.file.Close()
+}
+
+// lookup reads the block with the given key into buf, which must be
+// readCacheBlockSize bytes long. It returns false if the block isn't cached.
+func (rc *ReadCache) lookup(key readCacheDigest, buf []byte) bool {
+	rc.mu.Lock()
+	var digest readCacheDigest
+	slot, ok := rc.keys[key]
+	if ok {
+		digest = rc.keyEntries[slot].digest
+		slot, ok = rc.blocks[digest]
+	}
+	rc.mu.Unlock()
+	if !ok {
+		atomic.AddUint64(&rc.misses, 1)
+		return false
+	}
+
+	// The block is read without holding rc.mu, so it may be replaced
+	// concurrently, as it may be by other sandboxes; this is detected by
+	// verifying its digest.
+	if _, err := rc.file.ReadAt(buf, rc.dataOff+int64(slot)*readCacheBlockSize); err != nil {
+		log.Warningf("gofer read cache: failed to read block %d: %v", slot, err)
+		atomic.AddUint64(&rc.misses, 1)
+		return false
+	}
+	if readCacheDigest(sha256.Sum256(buf)) != digest {
+		rc.mu.Lock()
+		if s, ok := rc.blocks[digest]; ok && s == slot {
+			delete(rc.blocks, digest)
+		}
+		rc.mu.Unlock()
+		atomic.AddUint64(&rc.misses, 1)
+		return false
+	}
+	atomic.AddUint64(&rc.hits, 1)
+	return true
+}
+
+// store caches buf, which must be readCacheBlockSize bytes long, as the block
+// with the given key.
+func (rc *ReadCache) store(key readCacheDigest, buf []byte) {
+	digest := readCacheDigest(sha256.Sum256(buf))
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+
+	if _, ok := rc.blocks[digest]; !ok {
+		// Write the block before the block table entry that refers to it.
+		slot := rc.nextBlock
+		rc.nextBlock = (rc.nextBlock + 1) % rc.numBlocks
+		if old := rc.blockDigests[slot]; old != (readCacheDigest{}) {
+			delete(rc.blocks, old)
+			rc.blockDigests[slot] = readCacheDigest{}
+		}
+		if _, err := rc.file.WriteAt(buf, rc.dataOff+int64(slot)*readCacheBlockSize); err != nil {
+			log.Warningf("gofer read cache: failed to write block %d: %v", slot, err)
+			return
+		}
+		if _, err := rc.file.WriteAt(digest[:], rc.blockTableOff+int64(slot)*readCacheDigestSize); err != nil {
+			log.Warningf("gofer read cache: failed to write block table entry %d: %v", slot, err)
+			return
+		}
+		rc.blocks[digest] = slot
+		rc.blockDigests[slot] = digest
+	}
+
+	slot, ok := rc.keys[key]
+	if !ok {
+		slot = rc.nextKey
+		rc.nextKey = (rc.nextKey + 1) % uint32(len(rc.keyEntries))
+		if old := rc.keyEntries[slot].key; old != (readCacheDigest{}) {
+			delete(rc.keys, old)
+		}
+	}
+	e := readCacheKeyEntry{key: key, digest: digest}
+	var b [readCacheKeyEntrySize]byte
+	copy(b[:], e.key[:])
+	copy(b[readCacheDigestSize:], e.digest[:])
+	if _, err := rc.file.WriteAt(b[:], rc.keyTableOff+int64(slot)*readCacheKeyEntrySize); err != nil {
+		log.Warningf("gofer read cache: failed to write key table entry %d: %v", slot, err)
+		delete(rc.keys, key)
+		rc.keyEntries[slot] = readCacheKeyEntry{}
+		return
+	}
+	rc.keys[key] = slot
+	rc.keyEntries[slot] = e
+}
+
+// readCacheFile identifies a version of a remote file in a ReadCache.
+type readCacheFile struct {
+	// mount identifies the mount of the file's filesystem.
+	mount   string
+	qidPath uint64
+	size    uint64
+	mtime   int64
+	ctime   int64
+}
+
+// key returns the key of the given block of f.
+func (f *readCacheFile) key(block uint64) readCacheDigest {
+	h := sha256.New()
+	h.Write([]byte(f.mount))
+	var b [40]byte
+	le := binary.LittleEndian
+	le.PutUint64(b[0:], f.qidPath)
+	le.PutUint64(b[8:], f.size)
+	le.PutUint64(b[16:], uint64(f.mtime))
+	le.PutUint64(b[24:], uint64(f.ctime))
+	le.PutUint64(b[32:], block)
+	h.Write(b[:])
+	var key readCacheDigest
+	copy(key[:], h.Sum(nil))
+	return key
+}
+
+// readToBlocksAt reads the contents of f through rc, reading blocks that are
+// not cached from h and caching them.
+func (rc *ReadCache) readToBlocksAt(ctx context.Context, f *readCacheFile, h handle, dsts safemem.BlockSeq, offset uint64) (uint64, error) {
+	buf := rc.bufs.Get().([]byte)
+	defer rc.bufs.Put(buf)
+	var done uint64
+	for !dsts.IsEmpty() {
+		block := offset / readCacheBlockSize
+		blockStart := block * readCacheBlockSize
+		if blockStart+readCacheBlockSize > f.size {
+			// The last partial block of the file isn't cached.
+			n, err := h.readToBlocksAt(ctx, dsts, offset)
+			return done + n, err
+		}
+		key := f.key(block)
+		if !rc.lookup(key, buf) {
+			n, err := h.readToBlocksAt(ctx, safemem.BlockSeqOf(safemem.BlockFromSafeSlice(buf)), blockStart)
+			if n != readCacheBlockSize {
+				// The file may have changed; don't cache it.
+				if err == nil {
+					err = io.ErrUnexpectedEOF
+				}
+				log.Debugf("gofer read cache: short read of block %d of file %#x: %v", block, f.qidPath, err)
+				n, err := h.readToBlocksAt(ctx, dsts, offset)
+				return done + n, err
+			}
+			rc.store(key, buf)
+		}
+		n, err := safemem.CopySeq(dsts, safemem.BlockSeqOf(safemem.BlockFromSafeSlice(buf[offset-blockStart:])))
+		done += n
+		offset += n
+		dsts = dsts.DropFirst64(n)
+		if err != nil {
+			return done, err
+		}
+	}
+	return done, nil
+}
+
+// readToBlocksAtFunc returns the function that reads the contents of d
+// through h: h.readToBlocksAt, or a function that reads through the
+// filesystem's ReadCache if it is used for h.
+//
+// Preconditions: d.handleMu must be locked.
+func (d *dentry) readToBlocksAtFunc(h handle) func(context.Context, safemem.BlockSeq, uint64) (uint64, error) {
+	rc := d.fs.iopts.ReadCache
+	if rc == nil || h.fd >= 0 {
+		return h.readToBlocksAt
+	}
+	f := readCacheFile{
+		mount:   d.fs.iopts.UniqueID,
+		qidPath: d.qidPath,
+		size:    atomic.LoadUint64(&d.size),
+		mtime:   atomic.LoadInt64(&d.mtime),
+		ctime:   atomic.LoadInt64(&d.ctime),
+	}
+	return func(ctx context.Context, dsts safemem.BlockSeq, offset uint64) (uint64, error) {
+		return rc.readToBlocksAt(ctx, &f, h, dsts, offset)
+	}
+}
@@ -246,6 +246,13 @@ func (fd *regularFileFD) pwrite(ctx context.Context, src usermem.IOSequence, off
 	}
 
 	n, err := src.CopyInTo(ctx, rw)
+	if n > 0 && d.fs.opts.interop == InteropModeShared {
+		// The remote file's timestamps changed.
+		d.invalidateMetadata()
+	}
+	if n > 0 && d.writebackCaching() {
+		d.balanceDirty(ctx)
+	}
 	if err != nil {
 		return n, offset + n, err
 	}
@@ -358,7 +365,7 @@ func (rw *dentryReadWriter) ReadToBlocks(dsts safemem.BlockSeq) (uint64, error)
 	rw.d.handleMu.RLock()
 	h := rw.d.readHandleLocked()
 	if (rw.d.mmapFD >= 0 && !rw.d.fs.opts.forcePageCache) || rw.d.fs.opts.interop == InteropModeShared || rw.direct {
-		n, err := h.readToBlocksAt(rw.ctx, dsts, rw.off)
+		n, err := rw.d.readToBlocksAtFunc(h)(rw.ctx, dsts, rw.off)
 		rw.d.handleMu.RUnlock()
 		rw.off += n
 		return n, err
@@ -426,7 +433,7 @@ func (rw *dentryReadWriter) ReadToBlocks(dsts safemem.BlockSeq) (uint64, error)
 					End:   gapEnd,
 				}
 				optMR := gap.Range()
-				err := rw.d.cache.Fill(rw.ctx, reqMR, maxFillRange(reqMR, optMR), rw.d.size, mf, usage.PageCache, h.readToBlocksAt)
+				err := rw.d.cache.Fill(rw.ctx, reqMR, rw.d.fs.maxFillRange(reqMR, optMR), rw.d.size, mf, usage.PageCache, rw.d.readToBlocksAtFunc(h))
 				mf.MarkEvictable(rw.d, pgalloc.EvictableRange{optMR.Start, optMR.End})
 				seg, gap = rw.d.cache.Find(rw.off)
 				if !seg.Ok() {
@@ -441,7 +448,7 @@ func (rw *dentryReadWriter) ReadToBlocks(dsts safemem.BlockSeq) (uint64, error)
 			} else {
 				// Read directly from the file.
 				gapDsts := dsts.TakeFirst64(gapMR.Length())
-				n, err := h.readToBlocksAt(rw.ctx, gapDsts, gapMR.Start)
+				n, err := rw.d.readToBlocksAtFunc(h)(rw.ctx, gapDsts, gapMR.Start)
 				done += n
 				rw.off += n
 				dsts = dsts.DropFirst64(n)
@@ -505,6 +512,10 @@ func (rw *dentryReadWriter) WriteFromBlocks(srcs safemem.BlockSeq) (uint64, erro
 	var (
 		done   uint64
 		retErr error
+
+		// filledEnd is the end of the pages allocated for the write, which
+		// may be beyond the end of the file if the write is short.
+		filledEnd uint64
 	)
 	seg, gap := rw.d.cache.Find(rw.off)
 	for rw.off < end {
@@ -525,6 +536,7 @@ func (rw *dentryReadWriter) WriteFromBlocks(srcs safemem.BlockSeq) (uint64, erro
 			rw.off += n
 			srcs = srcs.DropFirst64(n)
 			rw.d.dirty.MarkDirty(segMR)
+			rw.d.dirtiedLocked(n)
 			if err != nil {
 				retErr = err
 				goto exitLoop
@@ -534,11 +546,33 @@ func (rw *dentryReadWriter) WriteFromBlocks(srcs safemem.BlockSeq) (uint64, erro
 			seg, gap = seg.NextNonEmpty()
 
 		case gap.Ok():
-			// Write directly to the file. At present, we never fill the cache
-			// when writing, since doing so can convert small writes into
-			// inefficient read-modify-write cycles, and we have no mechanism
-			// for detecting or avoiding this.
 			gapMR := gap.Range().Intersect(mr)
+			if rw.d.writebackCaching() {
+				// Allocate cache pages for the write, then re-enter the loop
+				// to write to the cache. This requires reading the remote
+				// file's data in partially-written pages.
+				if rh := rw.d.readHandleLocked(); rh.isOpen() {
+					err := rw.d.fillCacheForWriteLocked(rw.ctx, rh, gapMR)
+					if fillEnd, _ := usermem.PageRoundUp(gapMR.End); fillEnd > filledEnd {
+						filledEnd = fillEnd
+					}
+					seg, gap = rw.d.cache.Find(rw.off)
+					if !seg.Ok() {
+						retErr = err
+						goto exitLoop
+					}
+					// err might have occurred in part of gapMR after rw.off.
+					// If the error matters and persists, we'll run into it
+					// again in a later iteration of this loop.
+					continue
+				}
+			}
+
+			// Otherwise, write directly to the file. Without write-back
+			// caching, we never fill the cache when writing, since doing so
+			// can convert small writes into inefficient read-modify-write
+			// cycles, and we have no mechanism for detecting or avoiding
+			// this.
 			gapSrcs := srcs.TakeFirst64(gapMR.Length())
 			n, err := h.writeFromBlocksAt(rw.ctx, gapSrcs, gapMR.Start)
 			done += n
@@ -560,6 +594,10 @@ exitLoop:
 		// The remote file's size will implicitly be extended to the correct
 		// value when we write back to it.
 	}
+	if pgend, _ := usermem.PageRoundUp(rw.d.size); filledEnd > pgend {
+		// Don't cache pages after EOF.
+		rw.d.cache.Truncate(rw.d.size, mf)
+	}
 	// If InteropModeWritethrough is in effect, flush written data back to the
 	// remote filesystem.
 	if rw.d.fs.opts.interop == InteropModeWritethrough && done != 0 {
@@ -572,6 +610,7 @@ exitLoop:
 			done = 0
 			retErr = err
 		}
+		rw.d.cleanedLocked()
 	}
 	rw.d.dataMu.Unlock()
 	rw.d.handleMu.RUnlock()
@@ -595,10 +634,12 @@ func (d *dentry) writeback(ctx context.Context, offset, size int64) error {
 	if rend := offset + size; rend > offset && rend < end {
 		end = rend
 	}
-	return fsutil.SyncDirty(ctx, memmap.MappableRange{
+	err := fsutil.SyncDirty(ctx, memmap.MappableRange{
 		Start: uint64(offset),
 		End:   uint64(end),
 	}, &d.cache, &d.dirty, d.size, d.fs.mfp.MemoryFile(), h.writeFromBlocksAt)
+	d.cleanedLocked()
+	return err
 }
 
 // Seek implements vfs.FileDescriptionImpl.Seek.
@@ -658,6 +699,14 @@ func (fd *regularFileFD) Sync(ctx context.Context) error {
 	return fd.dentry().syncCachedFile(ctx, false /* lowSyncExpectations */)
 }
 
+// SyncRange implements vfs.FileDescriptionImplSyncRangeExtension.SyncRange.
+func (fd *regularFileFD) SyncRange(ctx context.Context, offset, length int64) error {
+	if length == 0 || offset+length < offset {
+		length = math.MaxInt64 - offset
+	}
+	return fd.dentry().writeback(ctx, offset, length)
+}
+
 // ConfigureMMap implements vfs.FileDescriptionImpl.ConfigureMMap.
 func (fd *regularFileFD) ConfigureMMap(ctx context.Context, opts *memmap.MMapOpts) error {
 	d := fd.dentry()
@@ -756,7 +805,7 @@ func (d *dentry) Translate(ctx context.Context, required, optional memmap.Mappab
 		d.handleMu.RUnlock()
 		mr := optional
 		if d.fs.opts.limitHostFDTranslation {
-			mr = maxFillRange(required, optional)
+			mr = d.fs.maxFillRange(required, optional)
 		}
 		return []memmap.Translation{
 			{
@@ -789,7 +838,7 @@ func (d *dentry) Translate(ctx context.Context, required, optional memmap.Mappab
 
 	mf := d.fs.mfp.MemoryFile()
 	h := d.readHandleLocked()
-	cerr := d.cache.Fill(ctx, required, maxFillRange(required, optional), d.size, mf, usage.PageCache, h.readToBlocksAt)
+	cerr := d.cache.Fill(ctx, required, d.fs.maxFillRange(required, optional), d.size, mf, usage.PageCache, d.readToBlocksAtFunc(h))
 
 	var ts []memmap.Translation
 	var translatedEnd uint64
@@ -830,8 +879,35 @@ func (d *dentry) Translate(ctx context.Context, required, optional memmap.Mappab
 	return ts, nil
 }
 
-func maxFillRange(required, optional memmap.MappableRange) memmap.MappableRange {
-	const maxReadahead = 64 << 10 // 64 KB, chosen arbitrarily
+// Prefetch implements memmap.Prefetcher.Prefetch.
+func (d *dentry) Prefetch(ctx context.Context, mr memmap.MappableRange) error {
+	d.handleMu.RLock()
+	defer d.handleMu.RUnlock()
+	if d.mmapFD >= 0 && !d.fs.opts.forcePageCache {
+		// Translations refer directly to the host file, so there is nothing
+		// for us to cache.
+		return nil
+	}
+
+	d.dataMu.Lock()
+	defer d.dataMu.Unlock()
+	// Constrain mr to d.size (rounded up), as in Translate.
+	pgend, _ := usermem.PageRoundUp(d.size)
+	if mr.End > pgend {
+		mr.End = pgend
+	}
+	if mr.Start >= mr.End {
+		return nil
+	}
+	mf := d.fs.mfp.MemoryFile()
+	h := d.readHandleLocked()
+	return d.cache.Fill(ctx, mr, mr, d.size, mf, usage.PageCache, d.readToBlocksAtFunc(h))
+}
+
+// maxFillRange returns the range of offsets, at least required and at most
+// optional, that should be read into the page cache.
+func (fs *filesystem) maxFillRange(required, optional memmap.MappableRange) memmap.MappableRange {
+	maxReadahead := fs.opts.readahead
 	if required.Length() >= maxReadahead {
 		return required
 	}
@@ -872,6 +948,7 @@ func (d *dentry) InvalidateUnsavable(ctx context.Context) error {
 	// been returned after we invalidated all existing translations above.
 	d.cache.DropAll(mf)
 	d.dirty.RemoveAll()
+	d.cleanedLocked()
 
 	return nil
 }
@@ -900,6 +977,7 @@ func (d *dentry) Evict(ctx context.Context, er pgalloc.EvictableRange) {
 		d.cache.Drop(mgapMR, mf)
 		d.dirty.KeepClean(mgapMR)
 	}
+	d.cleanedLocked()
 }
 
 // dentryPlatformFile implements memmap.File. It exists solely because dentry
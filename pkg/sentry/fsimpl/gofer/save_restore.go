@@ -70,7 +70,9 @@ func (fs *filesystem) PrepareSave(ctx context.Context) error {
 	}
 	fs.syncMu.Unlock()
 
-	// Flush local state to the remote filesystem.
+	// Flush local state to the remote filesystem. This also leaves the
+	// background flusher, if any, without dirty data to write back while fs
+	// is saved.
 	if err := fs.Sync(ctx); err != nil {
 		return err
 	}
@@ -177,6 +179,9 @@ func (fs *filesystem) CompleteRestore(ctx context.Context, opts vfs.CompleteRest
 		return err
 	}
 	fs.inoByQIDPath = make(map[uint64]uint64)
+	if fs.opts.invalidations {
+		fs.dentriesByQIDPath = make(map[uint64]map[*dentry]struct{})
+	}
 
 	// Restore the filesystem root.
 	ctx.UninterruptibleSleepStart(false)
@@ -227,6 +232,14 @@ func (fs *filesystem) CompleteRestore(ctx context.Context, opts vfs.CompleteRest
 	// Discard state only required during restore.
 	fs.savedDentryRW = nil
 
+	if fs.opts.invalidations {
+		go fs.watchInvalidations() // S/R-SAFE: started after restore.
+	}
+	if fs.opts.dirtyBytes != 0 {
+		fs.flusherWake = make(chan struct{}, 1)
+		go fs.runFlusher() // S/R-SAFE: started after restore.
+	}
+
 	return nil
 }
 
@@ -244,6 +257,9 @@ func (d *dentry) restoreFile(ctx context.Context, file p9file, qid p9.QID, attrM
 	d.fs.inoMu.Lock()
 	d.fs.inoByQIDPath[qid.Path] = d.ino
 	d.fs.inoMu.Unlock()
+	d.fs.syncMu.Lock()
+	d.fs.addDentryQIDPathLocked(d)
+	d.fs.syncMu.Unlock()
 
 	// Check metadata stability before updating metadata.
 	d.metadataMu.Lock()
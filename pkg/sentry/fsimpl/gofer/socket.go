@@ -19,6 +19,7 @@ import (
 
 	"gvisor.dev/gvisor/pkg/abi/linux"
 	"gvisor.dev/gvisor/pkg/context"
+	"gvisor.dev/gvisor/pkg/fdnotifier"
 	"gvisor.dev/gvisor/pkg/log"
 	"gvisor.dev/gvisor/pkg/p9"
 	"gvisor.dev/gvisor/pkg/sentry/fsimpl/host"
@@ -143,3 +144,149 @@ func (e *endpoint) Release(ctx context.Context) {
 func (e *endpoint) Passcred() bool {
 	return false
 }
+
+// bindHostSocketLocked creates a socket file with the given name in d that
+// host processes can connect to, and caches a dentry for it whose connections
+// are passed to ep, which is bound to the file.
+//
+// Preconditions:
+// * d.fs.renameMu must be locked.
+// * d.dirMu must be locked.
+// * !d.isSynthetic().
+func (d *dentry) bindHostSocketLocked(ctx context.Context, name string, ep transport.ExternalAcceptor, bep transport.BoundEndpoint, mode p9.FileMode, uid p9.UID, gid p9.GID, ds **[]*dentry) error {
+	sockFD, _, err := d.file.bind(ctx, uint32(ep.Type()), name, mode.Permissions(), uid, gid)
+	if err != nil {
+		return err
+	}
+	hostFD := sockFD.Release()
+	l, err := newHostListener(hostFD, ep)
+	if err != nil {
+		syscall.Close(hostFD)
+		d.file.unlinkAt(ctx, name, 0)
+		return err
+	}
+	qid, file, attrMask, attr, err := d.file.walkGetAttrOne(ctx, name)
+	if err != nil {
+		l.close()
+		d.file.unlinkAt(ctx, name, 0)
+		return err
+	}
+	child, err := d.fs.newDentry(ctx, file, qid, attrMask, &attr)
+	if err != nil {
+		file.close(ctx)
+		l.close()
+		d.file.unlinkAt(ctx, name, 0)
+		return err
+	}
+	*ds = appendDentry(*ds, child)
+	child.endpoint = bep
+	child.hostListener = l
+	child.IncRef() // reference held by child.hostListener
+	d.cacheNewChildLocked(child, name)
+	return nil
+}
+
+// stopHostListener stops passing connections from outside the sandbox to
+// d.endpoint.
+//
+// Preconditions:
+// * d.fs.renameMu must be locked.
+// * d.parent.dirMu must be locked, if d has a parent.
+// * d.hostListener != nil.
+func (d *dentry) stopHostListener() {
+	d.hostListener.close()
+	d.hostListener = nil
+	d.decRefNoCaching()
+}
+
+// hostListener accepts connections on a listening host socket, whose socket
+// file was created by binding a sentry endpoint on a gofer mount, and passes
+// them to the endpoint.
+//
+// Connections that arrive while the endpoint isn't listening, or while its
+// backlog is full, are accepted and immediately closed.
+type hostListener struct {
+	// fd is the listening host socket. fd is owned by the hostListener.
+	fd int
+
+	// ep is the endpoint to which accepted connections are passed.
+	ep transport.ExternalAcceptor
+
+	// queue is notified of events on fd.
+	queue waiter.Queue
+
+	// stop is closed to stop the hostListener's goroutine, which closes done
+	// before it exits.
+	stop chan struct{}
+	done chan struct{}
+}
+
+func newHostListener(hostFD int, ep transport.ExternalAcceptor) (*hostListener, error) {
+	l := &hostListener{
+		fd:   hostFD,
+		ep:   ep,
+		stop: make(chan struct{}),
+		done: make(chan struct{}),
+	}
+	if err := fdnotifier.AddFD(int32(hostFD), &l.queue); err != nil {
+		return nil, err
+	}
+	go l.run() // S/R-SAFE: host sockets are not saved.
+	return l, nil
+}
+
+func (l *hostListener) run() {
+	defer close(l.done)
+	e, ch := waiter.NewChannelEntry(nil)
+	l.queue.EventRegister(&e, waiter.EventIn)
+	defer l.queue.EventUnregister(&e)
+	fdnotifier.UpdateFD(int32(l.fd))
+	for {
+		l.acceptAll()
+		select {
+		case <-ch:
+		case <-l.stop:
+			return
+		}
+	}
+}
+
+// acceptAll accepts pending connections on l.fd until none remain.
+func (l *hostListener) acceptAll() {
+	ctx := context.Background()
+	for {
+		nfd, _, err := syscall.Accept4(l.fd, syscall.SOCK_NONBLOCK|syscall.SOCK_CLOEXEC)
+		if err != nil {
+			if err != syscall.EAGAIN {
+				log.Warningf("Failed to accept connection on host socket %d: %v", l.fd, err)
+			}
+			return
+		}
+		// Host peers are usually unnamed, and in any case their addresses
+		// are meaningless in the sandbox.
+		queue := &waiter.Queue{}
+		c, serr := host.NewSCMEndpoint(ctx, nfd, queue, "")
+		if serr != nil {
+			log.Warningf("Failed to create endpoint for host connection: %v", serr)
+			syscall.Close(nfd)
+			continue
+		}
+		if err := c.Init(); err != nil {
+			log.Warningf("Failed to register host connection: %v", err)
+			syscall.Close(nfd)
+			continue
+		}
+		if serr := l.ep.AcceptExternal(ctx, queue, c, c); serr != nil {
+			c.Release(ctx)
+			c.Release(ctx)
+		}
+	}
+}
+
+// close stops l and closes its host socket.
+func (l *hostListener) close() {
+	close(l.stop)
+	<-l.done
+	fdnotifier.RemoveFD(int32(l.fd))
+	syscall.Close(l.fd)
+}
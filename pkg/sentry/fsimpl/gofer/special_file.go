@@ -325,6 +325,9 @@ func (fd *specialFileFD) pwrite(ctx context.Context, src usermem.IOSequence, off
 			defer d.dataMu.Unlock()
 			atomic.StoreUint64(&d.size, uint64(offset))
 		}
+		if n > 0 && !d.cachedMetadataAuthoritative() {
+			d.invalidateMetadata()
+		}
 	}
 	if err != nil {
 		return int64(n), offset, err
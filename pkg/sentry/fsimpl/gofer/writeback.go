@@ -0,0 +1,255 @@
+// Copyright 2021 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gofer
+
+import (
+	"io"
+	"sync/atomic"
+	"time"
+
+	"gvisor.dev/gvisor/pkg/context"
+	"gvisor.dev/gvisor/pkg/log"
+	"gvisor.dev/gvisor/pkg/safemem"
+	"gvisor.dev/gvisor/pkg/sentry/fs/fsutil"
+	"gvisor.dev/gvisor/pkg/sentry/memmap"
+	"gvisor.dev/gvisor/pkg/sentry/pgalloc"
+	"gvisor.dev/gvisor/pkg/sentry/usage"
+	"gvisor.dev/gvisor/pkg/syserror"
+	"gvisor.dev/gvisor/pkg/usermem"
+)
+
+// If filesystemOptions.dirtyBytes is non-zero, writes to regular files for
+// which no host FD is available are written to the page cache and written
+// back to the remote file later ("write-back caching"), rather than written
+// to the remote file immediately:
+//
+// - A background flusher writes back dirty data that has been dirty for
+// longer than dirtyExpire, or all dirty data while the filesystem has more
+// than filesystemOptions.dirtyBackgroundBytes of it. Compare Linux's
+// mm/page-writeback.c:wb_over_bg_thresh() and
+// fs/fs-writeback.c:wb_check_old_data_flush().
+//
+// - Writers that leave the filesystem with more than
+// filesystemOptions.dirtyBytes of dirty data write back the dirty data of the
+// file they wrote to. Compare Linux's
+// mm/page-writeback.c:balance_dirty_pages().
+//
+// - fsync(2), sync_file_range(2), memory pressure, and the eviction of the
+// file's dentry write back dirty data as when write-back caching is disabled.
+//
+// The amount of dirty data is tracked approximately: data written to the
+// cache is counted when it is written, even if it was already dirty, and the
+// count is corrected when dirty data is written back. Pages dirtied through
+// memory mappings aren't counted.
+
+const (
+	// writebackInterval is the interval at which the background flusher
+	// looks for dirty data to write back. Compare Linux's
+	// vm.dirty_writeback_centisecs.
+	writebackInterval = 5 * time.Second
+
+	// dirtyExpire is the age at which dirty data is written back by the
+	// background flusher. Compare Linux's vm.dirty_expire_centisecs.
+	dirtyExpire = 30 * time.Second
+)
+
+// writebackCaching returns true if writes to d may be cached until they are
+// written back later.
+func (d *dentry) writebackCaching() bool {
+	return d.fs.opts.dirtyBytes != 0
+}
+
+// fillCacheForWriteLocked allocates cache pages for mr, which is about to be
+// written, so that the written data is written back to the remote file later.
+// Only the parts of partially-written pages that are outside of mr and before
+// the end of the file are read from the remote file, using h.
+//
+// Preconditions:
+// * d.handleMu must be locked.
+// * d.dataMu must be locked for writing.
+// * mr is a subset of a gap in d.cache.
+// * h.isOpen().
+func (d *dentry) fillCacheForWriteLocked(ctx context.Context, h handle, mr memmap.MappableRange) error {
+	end, ok := usermem.PageRoundUp(mr.End)
+	if !ok {
+		return syserror.EINVAL
+	}
+	pgMR := memmap.MappableRange{usermem.PageRoundDown(mr.Start), end}
+	size := d.size
+	readAt := func(ctx context.Context, dsts safemem.BlockSeq, off uint64) (uint64, error) {
+		n := dsts.NumBytes()
+		// Newly-allocated pages are zeroed, so only read the remote file's
+		// data that mr doesn't overwrite.
+		for _, r := range []memmap.MappableRange{
+			{off, mr.Start},
+			{mr.End, off + n},
+		} {
+			r = r.Intersect(memmap.MappableRange{off, size})
+			if r.Length() == 0 {
+				continue
+			}
+			rdsts := dsts.DropFirst64(r.Start - off).TakeFirst64(r.Length())
+			for !rdsts.IsEmpty() {
+				rn, err := h.readToBlocksAt(ctx, rdsts, r.Start)
+				rdsts = rdsts.DropFirst64(rn)
+				r.Start += rn
+				if err == io.EOF || (err == nil && rn == 0) {
+					// The rest of the range is beyond the end of the remote
+					// file, which is extended when dirty data is written
+					// back.
+					break
+				}
+				if err != nil {
+					return 0, err
+				}
+			}
+		}
+		return n, nil
+	}
+	mf := d.fs.mfp.MemoryFile()
+	err := d.cache.Fill(ctx, pgMR, pgMR, pgMR.End, mf, usage.PageCache, readAt)
+	mf.MarkEvictable(d, pgalloc.EvictableRange{pgMR.Start, pgMR.End})
+	return err
+}
+
+// dirtiedLocked accounts for n bytes of data written to d's cache, which
+// may have been dirty already.
+//
+// Preconditions: d.dataMu must be locked for writing.
+func (d *dentry) dirtiedLocked(n uint64) {
+	if n == 0 {
+		return
+	}
+	if atomic.LoadInt64(&d.dirtyBytes) == 0 {
+		atomic.StoreInt64(&d.dirtiedAt, time.Now().UnixNano())
+	}
+	atomic.AddInt64(&d.dirtyBytes, int64(n))
+	atomic.AddInt64(&d.fs.dirtyBytes, int64(n))
+}
+
+// cleanedLocked corrects the amount of dirty data accounted to d after dirty
+// data may have been written back or discarded.
+//
+// Preconditions: d.dataMu must be locked for writing.
+func (d *dentry) cleanedLocked() {
+	old := atomic.LoadInt64(&d.dirtyBytes)
+	if old == 0 {
+		return
+	}
+	var dirty int64
+	for seg := d.dirty.FirstSegment(); seg.Ok(); seg = seg.NextSegment() {
+		// Pages kept dirty by writable memory mappings aren't counted.
+		if !seg.Value().Keep {
+			dirty += int64(seg.Range().Length())
+		}
+	}
+	if dirty >= old {
+		// The count was exact, or too low due to pages dirtied through
+		// memory mappings.
+		return
+	}
+	atomic.StoreInt64(&d.dirtyBytes, dirty)
+	atomic.AddInt64(&d.fs.dirtyBytes, dirty-old)
+}
+
+// writebackAll writes back all of d's dirty cached data, without syncing the
+// remote file.
+func (d *dentry) writebackAll(ctx context.Context) error {
+	d.handleMu.RLock()
+	defer d.handleMu.RUnlock()
+	h := d.writeHandleLocked()
+	if !h.isOpen() {
+		return nil
+	}
+	d.dataMu.Lock()
+	defer d.dataMu.Unlock()
+	err := fsutil.SyncDirtyAll(ctx, &d.cache, &d.dirty, d.size, d.fs.mfp.MemoryFile(), h.writeFromBlocksAt)
+	d.cleanedLocked()
+	return err
+}
+
+// balanceDirty is called after writing to d's cache. It writes back d's dirty
+// data if the filesystem has too much of it, and wakes up the background
+// flusher if it should write back more.
+//
+// As in Linux, errors are not returned to the writer; data that couldn't be
+// written back remains dirty, and errors are returned by fsync(2).
+func (d *dentry) balanceDirty(ctx context.Context) {
+	fs := d.fs
+	dirty := atomic.LoadInt64(&fs.dirtyBytes)
+	if dirty <= int64(fs.opts.dirtyBackgroundBytes) {
+		return
+	}
+	fs.wakeFlusher()
+	if dirty <= int64(fs.opts.dirtyBytes) {
+		return
+	}
+	if err := d.writebackAll(ctx); err != nil {
+		ctx.Infof("gofer.dentry.balanceDirty: failed to write back dirty data: %v", err)
+	}
+}
+
+// wakeFlusher wakes up the background flusher.
+func (fs *filesystem) wakeFlusher() {
+	select {
+	case fs.flusherWake <- struct{}{}:
+	default:
+	}
+}
+
+// runFlusher writes back dirty cached data in the background until fs is
+// released.
+func (fs *filesystem) runFlusher() {
+	ticker := time.NewTicker(writebackInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-fs.flusherWake:
+		case <-ticker.C:
+		}
+		if atomic.LoadInt32(&fs.released) != 0 {
+			return
+		}
+		fs.flushDirty(context.Background())
+	}
+}
+
+// flushDirty writes back dirty cached data that has expired, or all dirty
+// cached data if the filesystem has more than dirtyBackgroundBytes of it.
+func (fs *filesystem) flushDirty(ctx context.Context) {
+	// Snapshot the dentries with dirty data. References aren't needed:
+	// dentry.destroyLocked() writes back and discards dirty data with
+	// d.handleMu and d.dataMu locked, so dentries destroyed concurrently
+	// have no dirty data left to write back by the time they are locked
+	// below.
+	fs.syncMu.Lock()
+	var ds []*dentry
+	for d := range fs.syncableDentries {
+		if atomic.LoadInt64(&d.dirtyBytes) != 0 {
+			ds = append(ds, d)
+		}
+	}
+	fs.syncMu.Unlock()
+
+	expired := time.Now().Add(-dirtyExpire).UnixNano()
+	for _, d := range ds {
+		if atomic.LoadInt64(&fs.dirtyBytes) <= int64(fs.opts.dirtyBackgroundBytes) && atomic.LoadInt64(&d.dirtiedAt) > expired {
+			continue
+		}
+		if err := d.writebackAll(ctx); err != nil {
+			log.Warningf("gofer.filesystem.flushDirty: failed to write back dirty data: %v", err)
+		}
+	}
+}
@@ -15,17 +15,141 @@
 package host
 
 import (
+	"fmt"
+	"strings"
 	"syscall"
 
 	"gvisor.dev/gvisor/pkg/abi/linux"
 	"gvisor.dev/gvisor/pkg/context"
+	"gvisor.dev/gvisor/pkg/sentry/fsimpl/kernfs"
 	"gvisor.dev/gvisor/pkg/sentry/kernel"
 	"gvisor.dev/gvisor/pkg/sentry/kernel/auth"
 	"gvisor.dev/gvisor/pkg/sentry/socket/control"
 	"gvisor.dev/gvisor/pkg/sentry/socket/unix/transport"
 	"gvisor.dev/gvisor/pkg/sentry/vfs"
+	"gvisor.dev/gvisor/pkg/syserr"
 )
 
+// SCMRightsPolicy is a set of file types that may be passed through host
+// Unix domain sockets in SCM_RIGHTS control messages.
+type SCMRightsPolicy uint32
+
+// SCMRightsPolicy values.
+const (
+	SCMRightsFile SCMRightsPolicy = 1 << iota
+	SCMRightsDirectory
+	SCMRightsPipe
+	SCMRightsSocket
+	SCMRightsCharDevice
+	SCMRightsBlockDevice
+	// SCMRightsOther covers files without a type, such as eventfds.
+	SCMRightsOther
+
+	SCMRightsNone SCMRightsPolicy = 0
+	SCMRightsAll                  = SCMRightsFile | SCMRightsDirectory | SCMRightsPipe | SCMRightsSocket | SCMRightsCharDevice | SCMRightsBlockDevice | SCMRightsOther
+)
+
+var scmRightsPolicyNames = map[string]SCMRightsPolicy{
+	"file":     SCMRightsFile,
+	"dir":      SCMRightsDirectory,
+	"pipe":     SCMRightsPipe,
+	"socket":   SCMRightsSocket,
+	"chardev":  SCMRightsCharDevice,
+	"blockdev": SCMRightsBlockDevice,
+	"other":    SCMRightsOther,
+	"none":     SCMRightsNone,
+	"all":      SCMRightsAll,
+}
+
+// ParseSCMRightsPolicy parses a comma-separated list of the file types "file",
+// "dir", "pipe", "socket", "chardev", "blockdev" and "other", or one of "all"
+// and "none".
+func ParseSCMRightsPolicy(s string) (SCMRightsPolicy, error) {
+	var p SCMRightsPolicy
+	for _, name := range strings.Split(s, ",") {
+		t, ok := scmRightsPolicyNames[name]
+		if !ok {
+			return 0, fmt.Errorf("unknown file type %q in SCM_RIGHTS policy %q", name, s)
+		}
+		p |= t
+	}
+	return p, nil
+}
+
+// allowsHostFD returns true if p allows passing hostFD.
+func (p SCMRightsPolicy) allowsHostFD(hostFD int) bool {
+	if p == SCMRightsAll {
+		return true
+	}
+	var s syscall.Stat_t
+	if err := syscall.Fstat(hostFD, &s); err != nil {
+		return false
+	}
+	var t SCMRightsPolicy
+	switch s.Mode & linux.S_IFMT {
+	case linux.S_IFREG:
+		t = SCMRightsFile
+	case linux.S_IFDIR:
+		t = SCMRightsDirectory
+	case linux.S_IFIFO:
+		t = SCMRightsPipe
+	case linux.S_IFSOCK:
+		t = SCMRightsSocket
+	case linux.S_IFCHR:
+		t = SCMRightsCharDevice
+	case linux.S_IFBLK:
+		t = SCMRightsBlockDevice
+	default:
+		t = SCMRightsOther
+	}
+	return p&t != 0
+}
+
+// AllowedSCMRights is the set of file types that may be sent and received
+// through host Unix domain sockets. Received files of other types are closed,
+// and the control message is reported as truncated; attempts to send files of
+// other types fail with EPERM.
+//
+// AllowedSCMRights must not change after host sockets are in use.
+var AllowedSCMRights = SCMRightsAll
+
+// filterSCMRights closes the host FDs in fds whose types are not allowed by
+// AllowedSCMRights, and returns the remaining FDs and whether any were
+// closed.
+func filterSCMRights(ctx context.Context, fds []int) ([]int, bool) {
+	allowed := fds[:0]
+	for _, fd := range fds {
+		if !AllowedSCMRights.allowsHostFD(fd) {
+			ctx.Infof("Dropping host FD %d received through SCM_RIGHTS: file type not allowed", fd)
+			syscall.Close(fd)
+			continue
+		}
+		allowed = append(allowed, fd)
+	}
+	return allowed, len(allowed) != len(fds)
+}
+
+// hostFDsOf returns the host FDs backing files, which must all have been
+// imported from the host and be allowed by AllowedSCMRights.
+func hostFDsOf(files control.RightsFilesVFS2) ([]int, *syserr.Error) {
+	fds := make([]int, 0, len(files))
+	for _, file := range files {
+		d, ok := file.Dentry().Impl().(*kernfs.Dentry)
+		if !ok {
+			return nil, syserr.ErrInvalidEndpointState
+		}
+		i, ok := d.Inode().(*inode)
+		if !ok {
+			return nil, syserr.ErrInvalidEndpointState
+		}
+		if !AllowedSCMRights.allowsHostFD(i.hostFD) {
+			return nil, syserr.ErrNotPermitted
+		}
+		fds = append(fds, i.hostFD)
+	}
+	return fds, nil
+}
+
 type scmRights struct {
 	fds []int
 }
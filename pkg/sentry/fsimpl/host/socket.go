@@ -142,15 +142,33 @@ func (c *ConnectedEndpoint) Send(ctx context.Context, data [][]byte, controlMess
 	c.mu.RLock()
 	defer c.mu.RUnlock()
 
-	if !controlMessages.Empty() {
+	// Credentials can't be passed to the host, but files that are backed
+	// by host FDs can, subject to AllowedSCMRights.
+	if controlMessages.Credentials != nil {
 		return 0, false, syserr.ErrInvalidEndpointState
 	}
+	var cm unet.ControlMessage
+	if controlMessages.Rights != nil {
+		files, ok := controlMessages.Rights.(*control.RightsFilesVFS2)
+		if !ok {
+			return 0, false, syserr.ErrInvalidEndpointState
+		}
+		fds, serr := hostFDsOf(*files)
+		if serr != nil {
+			return 0, false, serr
+		}
+		cm.PackFDs(fds...)
+	}
 
 	// Since stream sockets don't preserve message boundaries, we can write
 	// only as much of the message as fits in the send buffer.
 	truncate := c.stype == linux.SOCK_STREAM
 
-	n, totalLen, err := fdWriteVec(c.fd, data, c.sndbuf, truncate)
+	n, totalLen, err := fdWriteVec(c.fd, data, []byte(cm), c.sndbuf, truncate)
+	if n > 0 && controlMessages.Rights != nil {
+		// The host has taken its own references on the sent files.
+		controlMessages.Rights.Release(ctx)
+	}
 	if n < totalLen && err == nil {
 		// The host only returns a short write if it would otherwise
 		// block (and only for stream sockets).
@@ -254,6 +272,10 @@ func (c *ConnectedEndpoint) Recv(ctx context.Context, data [][]byte, creds bool,
 	if err != nil {
 		return 0, 0, transport.ControlMessages{}, false, tcpip.FullAddress{}, false, syserr.FromError(err)
 	}
+	fds, dropped := filterSCMRights(ctx, fds)
+	if dropped {
+		cTrunc = true
+	}
 
 	if len(fds) == 0 {
 		return rl, ml, transport.ControlMessages{}, cTrunc, tcpip.FullAddress{Addr: tcpip.Address(c.addr)}, false, nil
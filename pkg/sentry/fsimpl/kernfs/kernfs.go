@@ -508,6 +508,12 @@ func (d *Dentry) Inode() Inode {
 	return d.inode
 }
 
+// ParentOrSelf returns the dentry's parent, or the dentry itself if it is a
+// root.
+func (d *Dentry) ParentOrSelf() *Dentry {
+	return genericParentOrSelf(d)
+}
+
 // The Inode interface maps filesystem-level operations that operate on paths to
 // equivalent operations on specific filesystem nodes.
 //
@@ -0,0 +1,360 @@
+// Copyright 2021 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nfs
+
+import (
+	"io"
+	"math"
+
+	"gvisor.dev/gvisor/pkg/abi/linux"
+	"gvisor.dev/gvisor/pkg/context"
+	"gvisor.dev/gvisor/pkg/log"
+	"gvisor.dev/gvisor/pkg/nfs4"
+	"gvisor.dev/gvisor/pkg/sentry/fsimpl/kernfs"
+	"gvisor.dev/gvisor/pkg/sentry/kernel/auth"
+	"gvisor.dev/gvisor/pkg/sentry/vfs"
+	"gvisor.dev/gvisor/pkg/sync"
+	"gvisor.dev/gvisor/pkg/syserror"
+	"gvisor.dev/gvisor/pkg/usermem"
+)
+
+// fileDescription is embedded by NFS implementations of
+// vfs.FileDescriptionImpl.
+type fileDescription struct {
+	vfsfd vfs.FileDescription
+	vfs.FileDescriptionDefaultImpl
+	vfs.LockFD
+}
+
+func (fd *fileDescription) inode() *inode {
+	return fd.vfsfd.Dentry().Impl().(*kernfs.Dentry).Inode().(*inode)
+}
+
+// Stat implements vfs.FileDescriptionImpl.Stat.
+func (fd *fileDescription) Stat(ctx context.Context, opts vfs.StatOptions) (linux.Statx, error) {
+	return fd.inode().Stat(ctx, fd.vfsfd.Mount().Filesystem(), opts)
+}
+
+// SetStat implements vfs.FileDescriptionImpl.SetStat.
+func (fd *fileDescription) SetStat(ctx context.Context, opts vfs.SetStatOptions) error {
+	return fd.inode().setStat(ctx, auth.CredentialsFromContext(ctx), opts, nil)
+}
+
+// StatFS implements vfs.FileDescriptionImpl.StatFS.
+func (fd *fileDescription) StatFS(ctx context.Context) (linux.Statfs, error) {
+	return fd.inode().StatFS(ctx, fd.vfsfd.Mount().Filesystem())
+}
+
+// regularFileFD implements vfs.FileDescriptionImpl for regular files.
+type regularFileFD struct {
+	fileDescription
+
+	// stateid is the open state of the file on the server. stateid is
+	// immutable.
+	stateid nfs4.StateID
+
+	// offMu protects off.
+	offMu sync.Mutex
+
+	// off is the file offset.
+	off int64
+}
+
+// Release implements vfs.FileDescriptionImpl.Release.
+func (fd *regularFileFD) Release(ctx context.Context) {
+	i := fd.inode()
+	if err := i.fs.client.CloseState(ctxCredentials(ctx), i.fh, fd.stateid); err != nil {
+		log.Infof("nfs.regularFileFD.Release: CLOSE failed: %v", err)
+	}
+}
+
+// SetStat implements vfs.FileDescriptionImpl.SetStat.
+func (fd *regularFileFD) SetStat(ctx context.Context, opts vfs.SetStatOptions) error {
+	// ftruncate(2) uses the file's open state.
+	return fd.inode().setStat(ctx, auth.CredentialsFromContext(ctx), opts, &fd.stateid)
+}
+
+// PRead implements vfs.FileDescriptionImpl.PRead.
+func (fd *regularFileFD) PRead(ctx context.Context, dst usermem.IOSequence, offset int64, opts vfs.ReadOptions) (int64, error) {
+	if offset < 0 {
+		return 0, syserror.EINVAL
+	}
+	// Check that flags are supported.
+	//
+	// TODO(gvisor.dev/issue/2601): Support select preadv2 flags.
+	if opts.Flags&^linux.RWF_HIPRI != 0 {
+		return 0, syserror.EOPNOTSUPP
+	}
+
+	i := fd.inode()
+	client := i.fs.client
+	creds := ctxCredentials(ctx)
+	var total int64
+	for dst.NumBytes() > 0 {
+		count := uint32(client.MaxIO())
+		if n := dst.NumBytes(); n < int64(count) {
+			count = uint32(n)
+		}
+		data, eof, err := client.Read(creds, i.fh, fd.stateid, uint64(offset+total), count)
+		if err != nil {
+			return total, err
+		}
+		n, err := dst.CopyOut(ctx, data)
+		total += int64(n)
+		if err != nil {
+			return total, err
+		}
+		dst = dst.DropFirst(n)
+		if eof || len(data) == 0 {
+			break
+		}
+	}
+	if total == 0 && dst.NumBytes() != 0 {
+		return 0, io.EOF
+	}
+	return total, nil
+}
+
+// Read implements vfs.FileDescriptionImpl.Read.
+func (fd *regularFileFD) Read(ctx context.Context, dst usermem.IOSequence, opts vfs.ReadOptions) (int64, error) {
+	fd.offMu.Lock()
+	n, err := fd.PRead(ctx, dst, fd.off, opts)
+	fd.off += n
+	fd.offMu.Unlock()
+	return n, err
+}
+
+// PWrite implements vfs.FileDescriptionImpl.PWrite.
+func (fd *regularFileFD) PWrite(ctx context.Context, src usermem.IOSequence, offset int64, opts vfs.WriteOptions) (int64, error) {
+	n, _, err := fd.pwrite(ctx, src, offset, opts)
+	return n, err
+}
+
+// Write implements vfs.FileDescriptionImpl.Write.
+func (fd *regularFileFD) Write(ctx context.Context, src usermem.IOSequence, opts vfs.WriteOptions) (int64, error) {
+	fd.offMu.Lock()
+	n, off, err := fd.pwrite(ctx, src, fd.off, opts)
+	fd.off = off
+	fd.offMu.Unlock()
+	return n, err
+}
+
+// pwrite returns the number of bytes written, final offset and error. The
+// final offset should be ignored by PWrite.
+func (fd *regularFileFD) pwrite(ctx context.Context, src usermem.IOSequence, offset int64, opts vfs.WriteOptions) (written, finalOff int64, err error) {
+	if offset < 0 {
+		return 0, offset, syserror.EINVAL
+	}
+	// Check that flags are supported.
+	//
+	// TODO(gvisor.dev/issue/2601): Support select pwritev2 flags.
+	if opts.Flags&^linux.RWF_HIPRI != 0 {
+		return 0, offset, syserror.EOPNOTSUPP
+	}
+
+	i := fd.inode()
+	// Appending writes aren't atomic with respect to other clients of the
+	// server.
+	if fd.vfsfd.StatusFlags()&linux.O_APPEND != 0 {
+		attr, err := i.refreshAttr(ctx)
+		if err != nil {
+			return 0, offset, err
+		}
+		if attr.Size > math.MaxInt64 {
+			return 0, offset, syserror.EFBIG
+		}
+		offset = int64(attr.Size)
+	}
+	limit, err := vfs.CheckLimit(ctx, offset, src.NumBytes())
+	if err != nil {
+		return 0, offset, err
+	}
+	src = src.TakeFirst64(limit)
+
+	client := i.fs.client
+	creds := ctxCredentials(ctx)
+	buf := make([]byte, client.MaxIO())
+	for src.NumBytes() > 0 {
+		n, err := src.CopyIn(ctx, buf)
+		if n == 0 {
+			return written, offset + written, err
+		}
+		cn, werr := client.Write(creds, i.fh, fd.stateid, uint64(offset+written), buf[:n])
+		written += int64(cn)
+		if werr != nil {
+			return written, offset + written, werr
+		}
+		if int(cn) < n {
+			// Short write.
+			break
+		}
+		if err != nil {
+			return written, offset + written, err
+		}
+		src = src.DropFirst(n)
+	}
+	return written, offset + written, nil
+}
+
+// Seek implements vfs.FileDescriptionImpl.Seek.
+func (fd *regularFileFD) Seek(ctx context.Context, offset int64, whence int32) (int64, error) {
+	fd.offMu.Lock()
+	defer fd.offMu.Unlock()
+	switch whence {
+	case linux.SEEK_SET:
+		// Use offset as specified.
+	case linux.SEEK_CUR:
+		offset += fd.off
+	case linux.SEEK_END, linux.SEEK_DATA, linux.SEEK_HOLE:
+		attr, err := fd.inode().refreshAttr(ctx)
+		if err != nil {
+			return 0, err
+		}
+		size := int64(attr.Size)
+		// For SEEK_DATA and SEEK_HOLE, treat the file as a single
+		// contiguous segment of data.
+		switch whence {
+		case linux.SEEK_END:
+			offset += size
+		case linux.SEEK_DATA:
+			if offset >= size {
+				return 0, syserror.ENXIO
+			}
+			// Use offset as specified.
+		case linux.SEEK_HOLE:
+			if offset >= size {
+				return 0, syserror.ENXIO
+			}
+			offset = size
+		}
+	default:
+		return 0, syserror.EINVAL
+	}
+	if offset < 0 {
+		return 0, syserror.EINVAL
+	}
+	fd.off = offset
+	return offset, nil
+}
+
+// Sync implements vfs.FileDescriptionImpl.Sync.
+func (fd *regularFileFD) Sync(ctx context.Context) error {
+	// Writes are FILE_SYNC, so there is nothing to sync.
+	return nil
+}
+
+// directoryFD implements vfs.FileDescriptionImpl for directories.
+type directoryFD struct {
+	fileDescription
+	vfs.DirectoryFileDescriptionDefaultImpl
+
+	// mu protects off and dirents.
+	mu sync.Mutex
+
+	// off is the index of the next entry in dirents to be returned.
+	off int64
+
+	// dirents are the directory's entries, read when IterDirents is first
+	// called after the file offset is set to 0.
+	dirents []vfs.Dirent
+}
+
+// Release implements vfs.FileDescriptionImpl.Release.
+func (fd *directoryFD) Release(context.Context) {}
+
+// IterDirents implements vfs.FileDescriptionImpl.IterDirents.
+func (fd *directoryFD) IterDirents(ctx context.Context, cb vfs.IterDirentsCallback) error {
+	fd.mu.Lock()
+	defer fd.mu.Unlock()
+
+	if fd.dirents == nil {
+		ds, err := fd.getDirents(ctx)
+		if err != nil {
+			return err
+		}
+		fd.dirents = ds
+	}
+	for fd.off < int64(len(fd.dirents)) {
+		if err := cb.Handle(fd.dirents[fd.off]); err != nil {
+			return err
+		}
+		fd.off++
+	}
+	return nil
+}
+
+// getDirents reads all entries of the directory from the server. As in the
+// gofer client, all entries are read at once, since the server may skip or
+// repeat entries if the directory is modified between READDIRs.
+func (fd *directoryFD) getDirents(ctx context.Context) ([]vfs.Dirent, error) {
+	i := fd.inode()
+	ds, err := i.fs.client.ReadDir(ctxCredentials(ctx), i.fh)
+	if err != nil {
+		return nil, err
+	}
+
+	// The server doesn't return "." and "..", so they are generated here.
+	parent := fd.vfsfd.Dentry().Impl().(*kernfs.Dentry).ParentOrSelf().Inode().(*inode)
+	dirents := make([]vfs.Dirent, 0, len(ds)+2)
+	dirents = append(dirents, vfs.Dirent{
+		Name:    ".",
+		Type:    linux.DT_DIR,
+		Ino:     i.getAttr().FileID,
+		NextOff: 1,
+	}, vfs.Dirent{
+		Name:    "..",
+		Type:    linux.DT_DIR,
+		Ino:     parent.getAttr().FileID,
+		NextOff: 2,
+	})
+	for _, d := range ds {
+		dirents = append(dirents, vfs.Dirent{
+			Name:    d.Name,
+			Type:    fileTypes[d.Type].DirentType(),
+			Ino:     d.FileID,
+			NextOff: int64(len(dirents) + 1),
+		})
+	}
+	return dirents, nil
+}
+
+// Seek implements vfs.FileDescriptionImpl.Seek.
+func (fd *directoryFD) Seek(ctx context.Context, offset int64, whence int32) (int64, error) {
+	fd.mu.Lock()
+	defer fd.mu.Unlock()
+
+	switch whence {
+	case linux.SEEK_SET:
+		if offset < 0 {
+			return 0, syserror.EINVAL
+		}
+		if offset == 0 {
+			// Ensure that the next call to fd.IterDirents() reads the
+			// directory again.
+			fd.dirents = nil
+		}
+		fd.off = offset
+		return fd.off, nil
+	case linux.SEEK_CUR:
+		offset += fd.off
+		if offset < 0 {
+			return 0, syserror.EINVAL
+		}
+		fd.off = offset
+		return fd.off, nil
+	default:
+		return 0, syserror.EINVAL
+	}
+}
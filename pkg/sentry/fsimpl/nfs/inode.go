@@ -0,0 +1,425 @@
+// Copyright 2021 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nfs
+
+import (
+	"gvisor.dev/gvisor/pkg/abi/linux"
+	"gvisor.dev/gvisor/pkg/context"
+	"gvisor.dev/gvisor/pkg/nfs4"
+	"gvisor.dev/gvisor/pkg/sentry/fsimpl/kernfs"
+	"gvisor.dev/gvisor/pkg/sentry/kernel/auth"
+	"gvisor.dev/gvisor/pkg/sentry/vfs"
+	"gvisor.dev/gvisor/pkg/sync"
+	"gvisor.dev/gvisor/pkg/syserror"
+	"gvisor.dev/gvisor/pkg/usermem"
+)
+
+// inode implements kernfs.Inode.
+//
+// +stateify savable
+type inode struct {
+	inodeRefs
+	kernfs.InodeAlwaysValid
+
+	// fs is the owning filesystem. fs is immutable.
+	fs *filesystem
+
+	// fh is the file's handle. fh is immutable.
+	fh nfs4.FileHandle
+
+	locks vfs.FileLocks
+
+	// mu protects attr.
+	mu sync.Mutex `state:"nosave"`
+
+	// attr are the file's attributes, as last returned by the server. The
+	// file type in attr is immutable.
+	attr nfs4.Attr
+}
+
+// newInode returns a new inode for the file with the given handle and
+// attributes.
+func (fs *filesystem) newInode(fh nfs4.FileHandle, attr nfs4.Attr) *inode {
+	i := &inode{
+		fs:   fs,
+		fh:   fh,
+		attr: attr,
+	}
+	i.InitRefs()
+	return i
+}
+
+// setAttr updates i's cached attributes.
+func (i *inode) setAttr(attr nfs4.Attr) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	// The file type can't change; a server that reports otherwise has
+	// reused the file handle, which is not allowed.
+	attr.Type = i.attr.Type
+	i.attr = attr
+}
+
+// getAttr returns i's cached attributes.
+func (i *inode) getAttr() nfs4.Attr {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	return i.attr
+}
+
+// refreshAttr updates i's cached attributes from the server, and returns
+// them.
+func (i *inode) refreshAttr(ctx context.Context) (nfs4.Attr, error) {
+	attr, err := i.fs.client.GetAttr(ctxCredentials(ctx), i.fh)
+	if err != nil {
+		return nfs4.Attr{}, err
+	}
+	i.setAttr(attr)
+	return i.getAttr(), nil
+}
+
+// DecRef implements kernfs.Inode.DecRef.
+func (i *inode) DecRef(ctx context.Context) {
+	i.inodeRefs.DecRef(nil)
+}
+
+// Keep implements kernfs.Inode.Keep.
+func (i *inode) Keep() bool {
+	// Keep dentries in the dentry tree, since they refer to files on the
+	// server that may be unlinked or removed.
+	return true
+}
+
+// Mode implements kernfs.Inode.Mode.
+func (i *inode) Mode() linux.FileMode {
+	attr := i.getAttr()
+	return fileMode(&attr)
+}
+
+// CheckPermissions implements kernfs.Inode.CheckPermissions.
+//
+// Permissions are checked against the cached attributes; the server enforces
+// permissions again when the file is accessed.
+func (i *inode) CheckPermissions(ctx context.Context, creds *auth.Credentials, ats vfs.AccessTypes) error {
+	attr := i.getAttr()
+	return vfs.GenericCheckPermissions(creds, ats, fileMode(&attr), auth.KUID(attr.UID), auth.KGID(attr.GID))
+}
+
+// Stat implements kernfs.Inode.Stat.
+func (i *inode) Stat(ctx context.Context, fs *vfs.Filesystem, opts vfs.StatOptions) (linux.Statx, error) {
+	attr, err := i.refreshAttr(ctx)
+	if err != nil {
+		return linux.Statx{}, err
+	}
+	return i.statx(&attr), nil
+}
+
+// statx returns the statx of a file with the given attributes.
+func (i *inode) statx(attr *nfs4.Attr) linux.Statx {
+	return linux.Statx{
+		Mask:      linux.STATX_BASIC_STATS,
+		Blksize:   usermem.PageSize,
+		Nlink:     attr.NumLinks,
+		UID:       attr.UID,
+		GID:       attr.GID,
+		Mode:      uint16(fileMode(attr)),
+		Ino:       attr.FileID,
+		Size:      attr.Size,
+		Blocks:    (attr.SpaceUsed + 511) / 512,
+		Atime:     statxTimestamp(attr.Atime),
+		Ctime:     statxTimestamp(attr.Ctime),
+		Mtime:     statxTimestamp(attr.Mtime),
+		RdevMajor: attr.RdevMajor,
+		RdevMinor: attr.RdevMinor,
+		DevMajor:  linux.UNNAMED_MAJOR,
+		DevMinor:  i.fs.devMinor,
+	}
+}
+
+// statxTimestamp converts an NFS timestamp to a linux.StatxTimestamp.
+func statxTimestamp(t nfs4.Time) linux.StatxTimestamp {
+	return linux.StatxTimestamp{Sec: t.Sec, Nsec: t.Nsec}
+}
+
+// SetStat implements kernfs.Inode.SetStat.
+func (i *inode) SetStat(ctx context.Context, fs *vfs.Filesystem, creds *auth.Credentials, opts vfs.SetStatOptions) error {
+	return i.setStat(ctx, creds, opts, nil)
+}
+
+// setStat sets i's attributes. stateid is the stateid of an open file
+// description with which the file's size may be set, or nil.
+func (i *inode) setStat(ctx context.Context, creds *auth.Credentials, opts vfs.SetStatOptions, stateid *nfs4.StateID) error {
+	stat := &opts.Stat
+	if stat.Mask == 0 {
+		return nil
+	}
+	if stat.Mask&^(linux.STATX_MODE|linux.STATX_UID|linux.STATX_GID|linux.STATX_ATIME|linux.STATX_MTIME|linux.STATX_SIZE) != 0 {
+		return syserror.EPERM
+	}
+	attr := i.getAttr()
+	mode := fileMode(&attr)
+	if err := vfs.CheckSetStat(ctx, creds, &opts, mode, auth.KUID(attr.UID), auth.KGID(attr.GID)); err != nil {
+		return err
+	}
+	if stat.Mask&linux.STATX_SIZE != 0 {
+		switch {
+		case mode.IsDir():
+			return syserror.EISDIR
+		case mode.FileType() != linux.ModeRegular:
+			return syserror.EINVAL
+		}
+	}
+
+	sa := nfs4.SetAttr{
+		SetMode:  stat.Mask&linux.STATX_MODE != 0,
+		SetUID:   stat.Mask&linux.STATX_UID != 0,
+		SetGID:   stat.Mask&linux.STATX_GID != 0,
+		SetSize:  stat.Mask&linux.STATX_SIZE != 0,
+		SetAtime: stat.Mask&linux.STATX_ATIME != 0 && stat.Atime.Nsec != linux.UTIME_OMIT,
+		SetMtime: stat.Mask&linux.STATX_MTIME != 0 && stat.Mtime.Nsec != linux.UTIME_OMIT,
+		AtimeNow: stat.Atime.Nsec == linux.UTIME_NOW,
+		MtimeNow: stat.Mtime.Nsec == linux.UTIME_NOW,
+		Mode:     uint32(stat.Mode),
+		UID:      stat.UID,
+		GID:      stat.GID,
+		Size:     stat.Size,
+		Atime:    nfs4.Time{Sec: stat.Atime.Sec, Nsec: stat.Atime.Nsec},
+		Mtime:    nfs4.Time{Sec: stat.Mtime.Sec, Nsec: stat.Mtime.Nsec},
+	}
+	if !(sa.SetMode || sa.SetUID || sa.SetGID || sa.SetSize || sa.SetAtime || sa.SetMtime) {
+		return nil
+	}
+	newAttr, err := i.fs.client.SetAttr(nfsCredentials(creds), i.fh, stateid, &sa)
+	if err != nil {
+		return err
+	}
+	i.setAttr(newAttr)
+	return nil
+}
+
+// StatFS implements kernfs.Inode.StatFS.
+func (i *inode) StatFS(ctx context.Context, fs *vfs.Filesystem) (linux.Statfs, error) {
+	st, err := i.fs.client.StatFS(ctxCredentials(ctx), i.fh)
+	if err != nil {
+		return linux.Statfs{}, err
+	}
+	const blockSize = usermem.PageSize
+	return linux.Statfs{
+		Type:            linux.NFS_SUPER_MAGIC,
+		BlockSize:       blockSize,
+		FragmentSize:    blockSize,
+		Blocks:          st.SpaceTotal / blockSize,
+		BlocksFree:      st.SpaceFree / blockSize,
+		BlocksAvailable: st.SpaceAvail / blockSize,
+		Files:           st.FilesTotal,
+		FilesFree:       st.FilesFree,
+		NameLength:      uint64(st.MaxName),
+	}, nil
+}
+
+// Open implements kernfs.Inode.Open.
+func (i *inode) Open(ctx context.Context, rp *vfs.ResolvingPath, d *kernfs.Dentry, opts vfs.OpenOptions) (*vfs.FileDescription, error) {
+	attr := i.getAttr()
+	switch attr.Type {
+	case nfs4.TypeDirectory:
+		if vfs.AccessTypesForOpenFlags(&opts)&vfs.MayWrite != 0 {
+			return nil, syserror.EISDIR
+		}
+		fd := &directoryFD{}
+		fd.LockFD.Init(&i.locks)
+		if err := fd.vfsfd.Init(fd, opts.Flags, rp.Mount(), d.VFSDentry(), &vfs.FileDescriptionOptions{}); err != nil {
+			return nil, err
+		}
+		return &fd.vfsfd, nil
+
+	case nfs4.TypeRegular:
+		if opts.Mode.IsDir() {
+			return nil, syserror.ENOTDIR
+		}
+		return i.openRegular(ctx, rp, d, opts)
+
+	case nfs4.TypeBlock, nfs4.TypeChar:
+		if opts.Mode.IsDir() {
+			return nil, syserror.ENOTDIR
+		}
+		kind := vfs.CharDevice
+		if attr.Type == nfs4.TypeBlock {
+			kind = vfs.BlockDevice
+		}
+		return rp.VirtualFilesystem().OpenDeviceSpecialFile(ctx, rp.Mount(), d.VFSDentry(), kind, attr.RdevMajor, attr.RdevMinor, &opts)
+
+	default:
+		if opts.Mode.IsDir() {
+			return nil, syserror.ENOTDIR
+		}
+		// Named pipes and sockets are only meaningful on the client that
+		// created them.
+		return nil, syserror.ENXIO
+	}
+}
+
+// openRegular opens a regular file.
+func (i *inode) openRegular(ctx context.Context, rp *vfs.ResolvingPath, d *kernfs.Dentry, opts vfs.OpenOptions) (*vfs.FileDescription, error) {
+	var access uint32
+	ats := vfs.AccessTypesForOpenFlags(&opts)
+	if ats.MayRead() {
+		access |= nfs4.AccessRead
+	}
+	if ats.MayWrite() {
+		access |= nfs4.AccessWrite
+	}
+	if access == 0 {
+		// Open for neither reading nor writing, e.g. with O_PATH semantics;
+		// the server still requires an access mode.
+		access = nfs4.AccessRead
+	}
+	creds := auth.CredentialsFromContext(ctx)
+	stateid, err := i.fs.client.Open(nfsCredentials(creds), i.fh, access)
+	if err != nil {
+		return nil, err
+	}
+	fd := &regularFileFD{stateid: stateid}
+	fd.LockFD.Init(&i.locks)
+	if err := fd.vfsfd.Init(fd, opts.Flags, rp.Mount(), d.VFSDentry(), &vfs.FileDescriptionOptions{}); err != nil {
+		i.fs.client.CloseState(nfsCredentials(creds), i.fh, stateid)
+		return nil, err
+	}
+	if opts.Flags&linux.O_TRUNC != 0 && ats.MayWrite() {
+		if err := i.setStat(ctx, creds, vfs.SetStatOptions{Stat: linux.Statx{Mask: linux.STATX_SIZE}}, &fd.stateid); err != nil {
+			fd.vfsfd.DecRef(ctx)
+			return nil, err
+		}
+	}
+	return &fd.vfsfd, nil
+}
+
+// Lookup implements kernfs.Inode.Lookup.
+func (i *inode) Lookup(ctx context.Context, name string) (kernfs.Inode, error) {
+	fh, attr, err := i.fs.client.Lookup(ctxCredentials(ctx), i.fh, name)
+	if err != nil {
+		return nil, err
+	}
+	return i.fs.newInode(fh, attr), nil
+}
+
+// IterDirents implements kernfs.Inode.IterDirents. It is unused, since
+// directoryFD implements vfs.FileDescriptionImpl.IterDirents.
+func (i *inode) IterDirents(ctx context.Context, mnt *vfs.Mount, callback vfs.IterDirentsCallback, offset, relOffset int64) (int64, error) {
+	return offset, nil
+}
+
+// HasChildren implements kernfs.Inode.HasChildren.
+func (i *inode) HasChildren() bool {
+	// Whether the directory is empty is checked by the server.
+	return false
+}
+
+// NewFile implements kernfs.Inode.NewFile.
+func (i *inode) NewFile(ctx context.Context, name string, opts vfs.OpenOptions) (kernfs.Inode, error) {
+	fh, attr, err := i.fs.client.CreateFile(ctxCredentials(ctx), i.fh, name, uint32(opts.Mode))
+	if err != nil {
+		return nil, err
+	}
+	return i.fs.newInode(fh, attr), nil
+}
+
+// NewDir implements kernfs.Inode.NewDir.
+func (i *inode) NewDir(ctx context.Context, name string, opts vfs.MkdirOptions) (kernfs.Inode, error) {
+	return i.create(ctx, name, nfs4.TypeDirectory, opts.Mode, "", 0, 0)
+}
+
+// NewSymlink implements kernfs.Inode.NewSymlink.
+func (i *inode) NewSymlink(ctx context.Context, name, target string) (kernfs.Inode, error) {
+	return i.create(ctx, name, nfs4.TypeSymlink, 0777, target, 0, 0)
+}
+
+// NewNode implements kernfs.Inode.NewNode.
+func (i *inode) NewNode(ctx context.Context, name string, opts vfs.MknodOptions) (kernfs.Inode, error) {
+	switch opts.Mode.FileType() {
+	case linux.ModeRegular:
+		fh, attr, err := i.fs.client.CreateFile(ctxCredentials(ctx), i.fh, name, uint32(opts.Mode))
+		if err != nil {
+			return nil, err
+		}
+		return i.fs.newInode(fh, attr), nil
+	case linux.ModeCharacterDevice:
+		return i.create(ctx, name, nfs4.TypeChar, opts.Mode, "", opts.DevMajor, opts.DevMinor)
+	case linux.ModeBlockDevice:
+		return i.create(ctx, name, nfs4.TypeBlock, opts.Mode, "", opts.DevMajor, opts.DevMinor)
+	case linux.ModeNamedPipe:
+		return i.create(ctx, name, nfs4.TypeFIFO, opts.Mode, "", 0, 0)
+	case linux.ModeSocket:
+		return i.create(ctx, name, nfs4.TypeSocket, opts.Mode, "", 0, 0)
+	default:
+		return nil, syserror.EINVAL
+	}
+}
+
+// create creates a file that isn't a regular file.
+func (i *inode) create(ctx context.Context, name string, typ uint32, mode linux.FileMode, link string, rdevMajor, rdevMinor uint32) (kernfs.Inode, error) {
+	fh, attr, err := i.fs.client.Create(ctxCredentials(ctx), i.fh, name, typ, uint32(mode), link, rdevMajor, rdevMinor)
+	if err != nil {
+		return nil, err
+	}
+	return i.fs.newInode(fh, attr), nil
+}
+
+// NewLink implements kernfs.Inode.NewLink.
+func (i *inode) NewLink(ctx context.Context, name string, target kernfs.Inode) (kernfs.Inode, error) {
+	t, ok := target.(*inode)
+	if !ok || t.fs != i.fs {
+		return nil, syserror.EXDEV
+	}
+	attr, err := i.fs.client.Link(ctxCredentials(ctx), t.fh, i.fh, name)
+	if err != nil {
+		return nil, err
+	}
+	t.setAttr(attr)
+	// The new dentry takes a reference on target.
+	t.IncRef()
+	return t, nil
+}
+
+// Unlink implements kernfs.Inode.Unlink.
+func (i *inode) Unlink(ctx context.Context, name string, child kernfs.Inode) error {
+	return i.fs.client.Remove(ctxCredentials(ctx), i.fh, name)
+}
+
+// RmDir implements kernfs.Inode.RmDir.
+func (i *inode) RmDir(ctx context.Context, name string, child kernfs.Inode) error {
+	return i.fs.client.Remove(ctxCredentials(ctx), i.fh, name)
+}
+
+// Rename implements kernfs.Inode.Rename.
+func (i *inode) Rename(ctx context.Context, oldname, newname string, child, dstDir kernfs.Inode) error {
+	dst, ok := dstDir.(*inode)
+	if !ok || dst.fs != i.fs {
+		return syserror.EXDEV
+	}
+	return i.fs.client.Rename(ctxCredentials(ctx), i.fh, oldname, dst.fh, newname)
+}
+
+// Readlink implements kernfs.Inode.Readlink.
+func (i *inode) Readlink(ctx context.Context, mnt *vfs.Mount) (string, error) {
+	if i.getAttr().Type != nfs4.TypeSymlink {
+		return "", syserror.EINVAL
+	}
+	return i.fs.client.Readlink(ctxCredentials(ctx), i.fh)
+}
+
+// Getlink implements kernfs.Inode.Getlink.
+func (i *inode) Getlink(ctx context.Context, mnt *vfs.Mount) (vfs.VirtualDentry, string, error) {
+	target, err := i.Readlink(ctx, mnt)
+	return vfs.VirtualDentry{}, target, err
+}
@@ -0,0 +1,183 @@
+// Copyright 2021 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package nfs implements an NFSv4.1 client filesystem.
+//
+// The filesystem is given a host FD of a stream socket connected to the
+// server. Requests are sent with the AUTH_SYS credentials of the calling task,
+// so the server enforces permissions per user.
+//
+// Nothing is cached: file data is read from and written directly to the
+// server, and attributes are refreshed from the server by stat(2). Memory
+// mapping of files is not supported.
+//
+// Lock order:
+//
+// directoryFD.mu
+//   inode.mu
+package nfs
+
+import (
+	"encoding/hex"
+	"strconv"
+	"strings"
+
+	"gvisor.dev/gvisor/pkg/abi/linux"
+	"gvisor.dev/gvisor/pkg/context"
+	"gvisor.dev/gvisor/pkg/log"
+	"gvisor.dev/gvisor/pkg/nfs4"
+	"gvisor.dev/gvisor/pkg/rand"
+	"gvisor.dev/gvisor/pkg/sentry/fsimpl/kernfs"
+	"gvisor.dev/gvisor/pkg/sentry/kernel/auth"
+	"gvisor.dev/gvisor/pkg/sentry/vfs"
+	"gvisor.dev/gvisor/pkg/syserror"
+)
+
+// Name is the default filesystem name.
+const Name = "nfs4"
+
+// FilesystemType implements vfs.FilesystemType.
+//
+// +stateify savable
+type FilesystemType struct{}
+
+// filesystem implements vfs.FilesystemImpl.
+//
+// +stateify savable
+type filesystem struct {
+	kernfs.Filesystem
+
+	devMinor uint32
+
+	// client is the connection to the server. client is immutable.
+	client *nfs4.Client `state:"nosave"`
+}
+
+// Name implements vfs.FilesystemType.Name.
+func (FilesystemType) Name() string {
+	return Name
+}
+
+// Release implements vfs.FilesystemType.Release.
+func (FilesystemType) Release(ctx context.Context) {}
+
+// GetFilesystem implements vfs.FilesystemType.GetFilesystem.
+//
+// The mount data must specify the host FD of a stream socket connected to the
+// server as "fd=N", and may specify the path of the exported directory on the
+// server as "export=/path"; the server's root is mounted by default.
+func (fsType FilesystemType) GetFilesystem(ctx context.Context, vfsObj *vfs.VirtualFilesystem, creds *auth.Credentials, source string, opts vfs.GetFilesystemOptions) (*vfs.Filesystem, *vfs.Dentry, error) {
+	mopts := vfs.GenericParseMountOptions(opts.Data)
+	hostFDStr, ok := mopts["fd"]
+	if !ok {
+		log.Warningf("%s.GetFilesystem: host FD of a connection to the server must be specified as 'fd=N'", fsType.Name())
+		return nil, nil, syserror.EINVAL
+	}
+	delete(mopts, "fd")
+	hostFD, err := strconv.Atoi(hostFDStr)
+	if err != nil {
+		log.Warningf("%s.GetFilesystem: invalid host FD: fd=%s", fsType.Name(), hostFDStr)
+		return nil, nil, syserror.EINVAL
+	}
+	var export []string
+	if exportStr, ok := mopts["export"]; ok {
+		delete(mopts, "export")
+		for _, name := range strings.Split(exportStr, "/") {
+			if name != "" {
+				export = append(export, name)
+			}
+		}
+	}
+	if len(mopts) != 0 {
+		log.Warningf("%s.GetFilesystem: unsupported or unknown options: %v", fsType.Name(), mopts)
+		return nil, nil, syserror.EINVAL
+	}
+
+	// The client owner must be unique among the server's clients.
+	var ownerID [16]byte
+	if _, err := rand.Read(ownerID[:]); err != nil {
+		return nil, nil, err
+	}
+	client, err := nfs4.NewClient(hostFD, "gvisor-"+hex.EncodeToString(ownerID[:]))
+	if err != nil {
+		log.Warningf("%s.GetFilesystem: failed to establish NFS session: %v", fsType.Name(), err)
+		return nil, nil, syserror.EIO
+	}
+	rootFH, rootAttr, err := client.Root(nfsCredentials(creds), export)
+	if err != nil {
+		log.Warningf("%s.GetFilesystem: failed to look up export %q: %v", fsType.Name(), strings.Join(export, "/"), err)
+		client.Close()
+		return nil, nil, err
+	}
+	if rootAttr.Type != nfs4.TypeDirectory {
+		client.Close()
+		return nil, nil, syserror.ENOTDIR
+	}
+
+	devMinor, err := vfsObj.GetAnonBlockDevMinor()
+	if err != nil {
+		client.Close()
+		return nil, nil, err
+	}
+	fs := &filesystem{
+		devMinor: devMinor,
+		client:   client,
+	}
+	fs.VFSFilesystem().Init(vfsObj, &fsType, fs)
+
+	var root kernfs.Dentry
+	root.InitRoot(&fs.Filesystem, fs.newInode(rootFH, rootAttr))
+	return fs.VFSFilesystem(), root.VFSDentry(), nil
+}
+
+// Release implements vfs.FilesystemImpl.Release.
+func (fs *filesystem) Release(ctx context.Context) {
+	fs.client.Close()
+	fs.Filesystem.VFSFilesystem().VirtualFilesystem().PutAnonBlockDevMinor(fs.devMinor)
+	fs.Filesystem.Release(ctx)
+}
+
+// nfsCredentials returns the NFS credentials of creds.
+func nfsCredentials(creds *auth.Credentials) *nfs4.Credentials {
+	c := &nfs4.Credentials{
+		UID:    uint32(creds.EffectiveKUID),
+		GID:    uint32(creds.EffectiveKGID),
+		Groups: make([]uint32, 0, len(creds.ExtraKGIDs)),
+	}
+	for _, kgid := range creds.ExtraKGIDs {
+		c.Groups = append(c.Groups, uint32(kgid))
+	}
+	return c
+}
+
+// ctxCredentials returns the NFS credentials of the task in ctx.
+func ctxCredentials(ctx context.Context) *nfs4.Credentials {
+	return nfsCredentials(auth.CredentialsFromContext(ctx))
+}
+
+// fileTypes maps NFS file types to file type bits of a file mode.
+var fileTypes = map[uint32]linux.FileMode{
+	nfs4.TypeRegular:   linux.ModeRegular,
+	nfs4.TypeDirectory: linux.ModeDirectory,
+	nfs4.TypeBlock:     linux.ModeBlockDevice,
+	nfs4.TypeChar:      linux.ModeCharacterDevice,
+	nfs4.TypeSymlink:   linux.ModeSymlink,
+	nfs4.TypeSocket:    linux.ModeSocket,
+	nfs4.TypeFIFO:      linux.ModeNamedPipe,
+}
+
+// fileMode returns the file mode, including the file type, of attr.
+func fileMode(attr *nfs4.Attr) linux.FileMode {
+	return fileTypes[attr.Type] | linux.FileMode(attr.Mode&^linux.S_IFMT)
+}
@@ -227,6 +227,17 @@ func (d *dentry) copyUpLocked(ctx context.Context) error {
 		}); err != nil {
 			return err
 		}
+		if ftype == linux.S_IFCHR && oldStat.RdevMajor == 0 && oldStat.RdevMinor == 0 {
+			// d is visible, so it is an escaped whiteout on the lower layer,
+			// and must remain one on the upper layer.
+			if err := vfsObj.SetXattrAt(ctx, d.fs.creds, &newpop, &vfs.SetXattrOptions{
+				Name:  _OVL_XATTR_ESCAPED_WHITEOUT,
+				Value: "y",
+			}); err != nil {
+				cleanupUndoCopyUp()
+				return err
+			}
+		}
 		if err := vfsObj.SetStatAt(ctx, d.fs.creds, &newpop, &vfs.SetStatOptions{
 			Stat: linux.Statx{
 				Mask:  linux.STATX_UID | linux.STATX_GID | oldStat.Mask&timestampsMask,
@@ -339,7 +350,8 @@ func (d *dentry) copyUpLocked(ctx context.Context) error {
 }
 
 // copyXattrsLocked copies a subset of lower's extended attributes to upper.
-// Attributes that configure an overlay in the lower are not copied up.
+// Attributes that configure an overlay in the lower are not copied up, unlike
+// the escaped overlay attributes of users.
 //
 // Preconditions: d.copyMu must be locked for writing.
 func (d *dentry) copyXattrsLocked(ctx context.Context) error {
@@ -359,7 +371,7 @@ func (d *dentry) copyXattrsLocked(ctx context.Context) error {
 
 	for _, name := range lowerXattrs {
 		// Do not copy up overlay attributes.
-		if isOverlayXattr(name) {
+		if _, ok := unescapeXattr(name); !ok {
 			continue
 		}
 
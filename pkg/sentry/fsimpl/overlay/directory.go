@@ -77,16 +77,17 @@ func (d *dentry) collectWhiteoutsForRmdirLocked(ctx context.Context) (map[string
 		}
 
 		for _, maybeWhiteoutName := range maybeWhiteouts {
-			stat, err := vfsObj.StatAt(ctx, d.fs.creds, &vfs.PathOperation{
+			pop := &vfs.PathOperation{
 				Root:  layerVD,
 				Start: layerVD,
 				Path:  fspath.Parse(maybeWhiteoutName),
-			}, &vfs.StatOptions{})
+			}
+			stat, err := vfsObj.StatAt(ctx, d.fs.creds, pop, &vfs.StatOptions{})
 			if err != nil {
 				readdirErr = err
 				return false
 			}
-			if stat.RdevMajor != 0 || stat.RdevMinor != 0 {
+			if !d.fs.isWhiteoutAt(ctx, pop, &stat) {
 				// This file is a real character device, not a whiteout.
 				readdirErr = syserror.ENOTEMPTY
 				return false
@@ -221,16 +222,17 @@ func (d *dentry) getDirentsLocked(ctx context.Context) ([]vfs.Dirent, error) {
 		}
 
 		for _, dirent := range maybeWhiteouts {
-			stat, err := vfsObj.StatAt(ctx, d.fs.creds, &vfs.PathOperation{
+			pop := &vfs.PathOperation{
 				Root:  layerVD,
 				Start: layerVD,
 				Path:  fspath.Parse(dirent.Name),
-			}, &vfs.StatOptions{})
+			}
+			stat, err := vfsObj.StatAt(ctx, d.fs.creds, pop, &vfs.StatOptions{})
 			if err != nil {
 				readdirErr = err
 				return false
 			}
-			if stat.RdevMajor == 0 && stat.RdevMinor == 0 {
+			if d.fs.isWhiteoutAt(ctx, pop, &stat) {
 				// This file is a whiteout; don't emit a dirent for it.
 				continue
 			}
@@ -44,6 +44,26 @@ func isWhiteout(stat *linux.Statx) bool {
 	return stat.Mode&linux.S_IFMT == linux.S_IFCHR && stat.RdevMajor == 0 && stat.RdevMinor == 0
 }
 
+// _OVL_XATTR_ESCAPED_WHITEOUT is an extended attribute key set to "y" on
+// character devices with device number 0/0 that users of the overlay created.
+// They are files of the overlay rather than whiteouts, which lets overlays use
+// directories of this overlay as layers, as they create such devices as their
+// own whiteouts. Unlike other overlay attributes, this one is gVisor-specific.
+const _OVL_XATTR_ESCAPED_WHITEOUT = _OVL_XATTR_PREFIX + "escaped_whiteout"
+
+// isWhiteoutAt returns whether the file at pop in a layer, whose metadata is
+// stat, is a whiteout. Escaped whiteouts are not.
+func (fs *filesystem) isWhiteoutAt(ctx context.Context, pop *vfs.PathOperation, stat *linux.Statx) bool {
+	if !isWhiteout(stat) {
+		return false
+	}
+	escaped, err := fs.vfsfs.VirtualFilesystem().GetXattrAt(ctx, fs.creds, pop, &vfs.GetXattrOptions{
+		Name: _OVL_XATTR_ESCAPED_WHITEOUT,
+		Size: 1,
+	})
+	return err != nil || escaped != "y"
+}
+
 // Sync implements vfs.FilesystemImpl.Sync.
 func (fs *filesystem) Sync(ctx context.Context) error {
 	if fs.opts.UpperRoot.Ok() {
@@ -246,7 +266,7 @@ func (fs *filesystem) lookupLocked(ctx context.Context, parent *dentry, name str
 			return false
 		}
 
-		if isWhiteout(&stat) {
+		if fs.isWhiteoutAt(ctx, &vfs.PathOperation{Root: childVD, Start: childVD}, &stat) {
 			// This is a whiteout, so it "doesn't exist" on this layer, and
 			// layers below this one are ignored.
 			if isUpper {
@@ -348,11 +368,12 @@ func (fs *filesystem) lookupLayerLocked(ctx context.Context, parent *dentry, nam
 	var lookupErr error
 
 	parent.iterLayers(func(parentVD vfs.VirtualDentry, isUpper bool) bool {
-		stat, err := fs.vfsfs.VirtualFilesystem().StatAt(ctx, fs.creds, &vfs.PathOperation{
+		childPop := &vfs.PathOperation{
 			Root:  parentVD,
 			Start: parentVD,
 			Path:  childPath,
-		}, &vfs.StatOptions{
+		}
+		stat, err := fs.vfsfs.VirtualFilesystem().StatAt(ctx, fs.creds, childPop, &vfs.StatOptions{
 			Mask: linux.STATX_TYPE,
 		})
 		if err == syserror.ENOENT || err == syserror.ENAMETOOLONG {
@@ -371,7 +392,7 @@ func (fs *filesystem) lookupLayerLocked(ctx context.Context, parent *dentry, nam
 			lookupErr = syserror.EREMOTE
 			return false
 		}
-		if isWhiteout(&stat) {
+		if fs.isWhiteoutAt(ctx, childPop, &stat) {
 			// This is a whiteout, so it "doesn't exist" on this layer, and
 			// layers below this one are ignored.
 			if isUpper {
@@ -730,10 +751,9 @@ func (fs *filesystem) MkdirAt(ctx context.Context, rp *vfs.ResolvingPath, opts v
 // MknodAt implements vfs.FilesystemImpl.MknodAt.
 func (fs *filesystem) MknodAt(ctx context.Context, rp *vfs.ResolvingPath, opts vfs.MknodOptions) error {
 	return fs.doCreateAt(ctx, rp, false /* dir */, func(parent *dentry, childName string, haveUpperWhiteout bool) error {
-		// Disallow attempts to create whiteouts.
-		if opts.Mode&linux.S_IFMT == linux.S_IFCHR && opts.DevMajor == 0 && opts.DevMinor == 0 {
-			return syserror.EPERM
-		}
+		// Character devices with device number 0/0 would be whiteouts in the
+		// upper layer, so they are marked as escaped whiteouts.
+		escapeWhiteout := opts.Mode&linux.S_IFMT == linux.S_IFCHR && opts.DevMajor == 0 && opts.DevMinor == 0
 		vfsObj := fs.vfsfs.VirtualFilesystem()
 		pop := vfs.PathOperation{
 			Root:  parent.upperVD,
@@ -751,6 +771,21 @@ func (fs *filesystem) MknodAt(ctx context.Context, rp *vfs.ResolvingPath, opts v
 			}
 			return err
 		}
+		if escapeWhiteout {
+			if err := vfsObj.SetXattrAt(ctx, fs.creds, &pop, &vfs.SetXattrOptions{
+				Name:  _OVL_XATTR_ESCAPED_WHITEOUT,
+				Value: "y",
+			}); err != nil {
+				if cleanupErr := vfsObj.UnlinkAt(ctx, fs.creds, &pop); cleanupErr != nil {
+					panic(fmt.Sprintf("unrecoverable overlayfs inconsistency: failed to delete upper layer file after MknodAt escaped whiteout marking failure: %v", cleanupErr))
+				} else if haveUpperWhiteout {
+					fs.cleanupRecreateWhiteout(ctx, vfsObj, &pop)
+				}
+				// The upper layer can't tell escaped whiteouts apart from
+				// whiteouts.
+				return syserror.EPERM
+			}
+		}
 		creds := rp.Credentials()
 		if err := vfsObj.SetStatAt(ctx, fs.creds, &pop, &vfs.SetStatOptions{
 			Stat: linux.Statx{
@@ -1031,9 +1066,12 @@ func (fs *filesystem) ReadlinkAt(ctx context.Context, rp *vfs.ResolvingPath) (st
 
 // RenameAt implements vfs.FilesystemImpl.RenameAt.
 func (fs *filesystem) RenameAt(ctx context.Context, rp *vfs.ResolvingPath, oldParentVD vfs.VirtualDentry, oldName string, opts vfs.RenameOptions) error {
-	if opts.Flags != 0 {
+	// Like Linux's overlayfs, we don't support RENAME_WHITEOUT.
+	if opts.Flags&^(linux.RENAME_NOREPLACE|linux.RENAME_EXCHANGE) != 0 {
 		return syserror.EINVAL
 	}
+	noReplace := opts.Flags&linux.RENAME_NOREPLACE != 0
+	exchange := opts.Flags&linux.RENAME_EXCHANGE != 0
 
 	var ds *[]*dentry
 	fs.renameMu.Lock()
@@ -1044,6 +1082,9 @@ func (fs *filesystem) RenameAt(ctx context.Context, rp *vfs.ResolvingPath, oldPa
 	}
 	newName := rp.Component()
 	if newName == "." || newName == ".." {
+		if noReplace {
+			return syserror.EEXIST
+		}
 		return syserror.EBUSY
 	}
 	mnt := rp.Mount()
@@ -1081,7 +1122,7 @@ func (fs *filesystem) RenameAt(ctx context.Context, rp *vfs.ResolvingPath, oldPa
 			}
 		}
 	} else {
-		if opts.MustBeDir || rp.MustBeDir() {
+		if opts.MustBeDir || (!exchange && rp.MustBeDir()) {
 			return syserror.ENOTDIR
 		}
 	}
@@ -1107,8 +1148,29 @@ func (fs *filesystem) RenameAt(ctx context.Context, rp *vfs.ResolvingPath, oldPa
 		return err
 	}
 	if replaced != nil {
+		if noReplace {
+			return syserror.EEXIST
+		}
 		replacedVFSD = &replaced.vfsd
-		if replaced.isDir() {
+		if exchange {
+			if err := vfs.CheckDeleteSticky(creds, linux.FileMode(atomic.LoadUint32(&newParent.mode)), auth.KUID(atomic.LoadUint32(&replaced.uid))); err != nil {
+				return err
+			}
+			if replaced.isDir() {
+				if replaced == oldParent || genericIsAncestorDentry(replaced, oldParent) {
+					return syserror.EINVAL
+				}
+				if oldParent != newParent {
+					if err := replaced.checkPermissions(creds, vfs.MayWrite); err != nil {
+						return err
+					}
+				}
+				replaced.dirMu.Lock()
+				defer replaced.dirMu.Unlock()
+			} else if rp.MustBeDir() {
+				return syserror.ENOTDIR
+			}
+		} else if replaced.isDir() {
 			if !renamed.isDir() {
 				return syserror.EISDIR
 			}
@@ -1128,10 +1190,18 @@ func (fs *filesystem) RenameAt(ctx context.Context, rp *vfs.ResolvingPath, oldPa
 		}
 	}
 
+	if exchange && replaced == nil {
+		return syserror.ENOENT
+	}
+
 	if oldParent == newParent && oldName == newName {
 		return nil
 	}
 
+	if exchange {
+		return fs.renameExchangeLocked(ctx, rp, oldParent, oldName, renamed, newParent, newName, replaced, &ds)
+	}
+
 	// renamed and oldParent need to be copied-up before they're renamed on the
 	// upper layer.
 	if err := renamed.copyUpLocked(ctx); err != nil {
@@ -1226,7 +1296,11 @@ func (fs *filesystem) RenameAt(ctx context.Context, rp *vfs.ResolvingPath, oldPa
 		Start: oldParent.upperVD,
 		Path:  fspath.Parse(oldName),
 	}
-	if err := vfsObj.RenameAt(ctx, creds, &oldpop, &newpop, &opts); err != nil {
+	// RENAME_NOREPLACE was checked above; it would spuriously fail on the
+	// upper layer if newName is a whiteout.
+	upperOpts := opts
+	upperOpts.Flags &^= linux.RENAME_NOREPLACE
+	if err := vfsObj.RenameAt(ctx, creds, &oldpop, &newpop, &upperOpts); err != nil {
 		cleanupRecreateWhiteouts()
 		vfsObj.AbortRenameDentry(&renamed.vfsd, replacedVFSD)
 		return err
@@ -1272,6 +1346,86 @@ func (fs *filesystem) RenameAt(ctx context.Context, rp *vfs.ResolvingPath, oldPa
 	return nil
 }
 
+// renameExchangeLocked atomically exchanges renamed, the child of oldParent
+// named oldName, with replaced, the child of newParent named newName.
+//
+// Preconditions:
+// * fs.renameMu must be locked.
+// * oldParent.dirMu and newParent.dirMu must be locked.
+// * If renamed or replaced is a directory, its dirMu must be locked.
+// * renamed != replaced.
+func (fs *filesystem) renameExchangeLocked(ctx context.Context, rp *vfs.ResolvingPath, oldParent *dentry, oldName string, renamed *dentry, newParent *dentry, newName string, replaced *dentry, ds **[]*dentry) error {
+	// Both files, and all of their descendants, need to be copied-up before
+	// they're exchanged on the upper layer. This also copies-up oldParent and
+	// newParent.
+	for _, d := range []*dentry{renamed, replaced} {
+		if err := d.copyUpLocked(ctx); err != nil {
+			return err
+		}
+		if d.isDir() {
+			if err := d.copyUpDescendantsLocked(ctx, ds); err != nil {
+				return err
+			}
+		}
+	}
+
+	vfsObj := rp.VirtualFilesystem()
+	mntns := vfs.MountNamespaceFromContext(ctx)
+	defer mntns.DecRef(ctx)
+	if err := vfsObj.PrepareRenameDentry(mntns, &renamed.vfsd, &replaced.vfsd); err != nil {
+		return err
+	}
+	oldpop := vfs.PathOperation{
+		Root:  oldParent.upperVD,
+		Start: oldParent.upperVD,
+		Path:  fspath.Parse(oldName),
+	}
+	newpop := vfs.PathOperation{
+		Root:  newParent.upperVD,
+		Start: newParent.upperVD,
+		Path:  fspath.Parse(newName),
+	}
+	if err := vfsObj.RenameAt(ctx, rp.Credentials(), &oldpop, &newpop, &vfs.RenameOptions{
+		Flags: linux.RENAME_EXCHANGE,
+	}); err != nil {
+		vfsObj.AbortRenameDentry(&renamed.vfsd, &replaced.vfsd)
+		return err
+	}
+
+	// Below this point, the files have been exchanged on the upper layer.
+	// Commit the rename, update the overlay filesystem tree, and abandon
+	// attempts to recover from errors. Since each parent gains one child and
+	// loses another, their references don't change.
+	vfsObj.CommitRenameExchangeDentry(&renamed.vfsd, &replaced.vfsd)
+	renamed.parent, replaced.parent = newParent, oldParent
+	renamed.name, replaced.name = newName, oldName
+	oldParent.children[oldName] = replaced
+	newParent.children[newName] = renamed
+	oldParent.dirents = nil
+	newParent.dirents = nil
+
+	// Directories must not be merged with lower layer directories at their new
+	// locations.
+	for _, pd := range []struct {
+		d   *dentry
+		pop *vfs.PathOperation
+	}{{renamed, &newpop}, {replaced, &oldpop}} {
+		if !pd.d.isDir() {
+			continue
+		}
+		if err := vfsObj.SetXattrAt(ctx, fs.creds, pd.pop, &vfs.SetXattrOptions{
+			Name:  _OVL_XATTR_OPAQUE,
+			Value: "y",
+		}); err != nil {
+			panic(fmt.Sprintf("unrecoverable overlayfs inconsistency: failed to make exchanged directory opaque: %v", err))
+		}
+	}
+
+	vfs.InotifyRename(ctx, &renamed.watches, &oldParent.watches, &newParent.watches, oldName, newName, renamed.isDir())
+	vfs.InotifyRename(ctx, &replaced.watches, &newParent.watches, &oldParent.watches, newName, oldName, replaced.isDir())
+	return nil
+}
+
 // RmdirAt implements vfs.FilesystemImpl.RmdirAt.
 func (fs *filesystem) RmdirAt(ctx context.Context, rp *vfs.ResolvingPath) error {
 	var ds *[]*dentry
@@ -1637,6 +1791,34 @@ func isOverlayXattr(name string) bool {
 	return strings.HasPrefix(name, _OVL_XATTR_PREFIX)
 }
 
+// _OVL_XATTR_ESCAPE_PREFIX is the prefix of the extended attributes of layer
+// files that users of the overlay see as overlay attributes, without the
+// extra "overlay.". Escaping lets overlays use directories of this overlay as
+// layers. See fs/overlayfs/xattrs.c:ovl_xattr_escape_name() in Linux 6.7.
+const _OVL_XATTR_ESCAPE_PREFIX = _OVL_XATTR_PREFIX + "overlay."
+
+// escapeXattr returns the name of the extended attribute of layer files that
+// stores the given extended attribute of overlay files.
+func escapeXattr(name string) string {
+	if isOverlayXattr(name) {
+		return _OVL_XATTR_ESCAPE_PREFIX + strings.TrimPrefix(name, _OVL_XATTR_PREFIX)
+	}
+	return name
+}
+
+// unescapeXattr returns the name that users of the overlay see for the given
+// extended attribute of layer files, or false if they don't see it because it
+// configures the overlay.
+func unescapeXattr(name string) (string, bool) {
+	if !isOverlayXattr(name) {
+		return name, true
+	}
+	if strings.HasPrefix(name, _OVL_XATTR_ESCAPE_PREFIX) {
+		return _OVL_XATTR_PREFIX + strings.TrimPrefix(name, _OVL_XATTR_ESCAPE_PREFIX), true
+	}
+	return "", false
+}
+
 // ListXattrAt implements vfs.FilesystemImpl.ListXattrAt.
 func (fs *filesystem) ListXattrAt(ctx context.Context, rp *vfs.ResolvingPath, size uint64) ([]string, error) {
 	var ds *[]*dentry
@@ -1658,10 +1840,10 @@ func (fs *filesystem) listXattr(ctx context.Context, d *dentry, size uint64) ([]
 		return nil, err
 	}
 
-	// Filter out all overlay attributes.
+	// Filter out all overlay attributes, and unescape those of users.
 	n := 0
 	for _, name := range names {
-		if !isOverlayXattr(name) {
+		if name, ok := unescapeXattr(name); ok {
 			names[n] = name
 			n++
 		}
@@ -1687,16 +1869,13 @@ func (fs *filesystem) getXattr(ctx context.Context, d *dentry, creds *auth.Crede
 		return "", err
 	}
 
-	// Return EOPNOTSUPP when fetching an overlay attribute.
-	// See fs/overlayfs/super.c:ovl_own_xattr_get().
-	if isOverlayXattr(opts.Name) {
-		return "", syserror.EOPNOTSUPP
-	}
-
-	// Analogous to fs/overlayfs/super.c:ovl_other_xattr_get().
+	// Analogous to fs/overlayfs/super.c:ovl_other_xattr_get(). Overlay
+	// attributes are escaped, as in fs/overlayfs/xattrs.c:ovl_own_xattr_get().
 	vfsObj := d.fs.vfsfs.VirtualFilesystem()
 	top := d.topLayer()
-	return vfsObj.GetXattrAt(ctx, fs.creds, &vfs.PathOperation{Root: top, Start: top}, opts)
+	layerOpts := *opts
+	layerOpts.Name = escapeXattr(opts.Name)
+	return vfsObj.GetXattrAt(ctx, fs.creds, &vfs.PathOperation{Root: top, Start: top}, &layerOpts)
 }
 
 // SetXattrAt implements vfs.FilesystemImpl.SetXattrAt.
@@ -1725,13 +1904,8 @@ func (fs *filesystem) setXattrLocked(ctx context.Context, d *dentry, mnt *vfs.Mo
 		return err
 	}
 
-	// Return EOPNOTSUPP when setting an overlay attribute.
-	// See fs/overlayfs/super.c:ovl_own_xattr_set().
-	if isOverlayXattr(opts.Name) {
-		return syserror.EOPNOTSUPP
-	}
-
-	// Analogous to fs/overlayfs/super.c:ovl_other_xattr_set().
+	// Analogous to fs/overlayfs/super.c:ovl_other_xattr_set(). Overlay
+	// attributes are escaped, as in fs/overlayfs/xattrs.c:ovl_own_xattr_set().
 	if err := mnt.CheckBeginWrite(); err != nil {
 		return err
 	}
@@ -1740,7 +1914,9 @@ func (fs *filesystem) setXattrLocked(ctx context.Context, d *dentry, mnt *vfs.Mo
 		return err
 	}
 	vfsObj := d.fs.vfsfs.VirtualFilesystem()
-	return vfsObj.SetXattrAt(ctx, fs.creds, &vfs.PathOperation{Root: d.upperVD, Start: d.upperVD}, opts)
+	layerOpts := *opts
+	layerOpts.Name = escapeXattr(opts.Name)
+	return vfsObj.SetXattrAt(ctx, fs.creds, &vfs.PathOperation{Root: d.upperVD, Start: d.upperVD}, &layerOpts)
 }
 
 // RemoveXattrAt implements vfs.FilesystemImpl.RemoveXattrAt.
@@ -1769,13 +1945,8 @@ func (fs *filesystem) removeXattrLocked(ctx context.Context, d *dentry, mnt *vfs
 		return err
 	}
 
-	// Like SetXattrAt, return EOPNOTSUPP when removing an overlay attribute.
-	// Linux passes the remove request to xattr_handler->set.
-	// See fs/xattr.c:vfs_removexattr().
-	if isOverlayXattr(name) {
-		return syserror.EOPNOTSUPP
-	}
-
+	// Like SetXattrAt, overlay attributes are escaped. Linux passes the
+	// remove request to xattr_handler->set. See fs/xattr.c:vfs_removexattr().
 	if err := mnt.CheckBeginWrite(); err != nil {
 		return err
 	}
@@ -1784,7 +1955,7 @@ func (fs *filesystem) removeXattrLocked(ctx context.Context, d *dentry, mnt *vfs
 		return err
 	}
 	vfsObj := d.fs.vfsfs.VirtualFilesystem()
-	return vfsObj.RemoveXattrAt(ctx, fs.creds, &vfs.PathOperation{Root: d.upperVD, Start: d.upperVD}, name)
+	return vfsObj.RemoveXattrAt(ctx, fs.creds, &vfs.PathOperation{Root: d.upperVD, Start: d.upperVD}, escapeXattr(name))
 }
 
 // PrependPath implements vfs.FilesystemImpl.PrependPath.
@@ -213,6 +213,29 @@ func (fstype FilesystemType) GetFilesystem(ctx context.Context, vfsObj *vfs.Virt
 		}
 	}
 
+	// Options that select unimplemented features are accepted when they
+	// disable them, since container runtimes that use overlays pass them.
+	for opt, disabled := range map[string]string{
+		"index":        "off",
+		"metacopy":     "off",
+		"nfs_export":   "off",
+		"redirect_dir": "off",
+		"xino":         "off",
+	} {
+		if v, ok := mopts[opt]; ok {
+			if v != disabled {
+				ctx.Infof("overlay.FilesystemType.GetFilesystem: unsupported option %s=%s", opt, v)
+				return nil, nil, syserror.EINVAL
+			}
+			delete(mopts, opt)
+		}
+	}
+	// volatile only allows skipping syncs of the upper layer, and userxattr
+	// only changes the names of the extended attributes of layers, which are
+	// specific to this implementation anyway.
+	delete(mopts, "volatile")
+	delete(mopts, "userxattr")
+
 	if len(mopts) != 0 {
 		ctx.Infof("overlay.FilesystemType.GetFilesystem: unused options: %v", mopts)
 		return nil, nil, syserror.EINVAL
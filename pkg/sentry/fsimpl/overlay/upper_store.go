@@ -0,0 +1,678 @@
+// Copyright 2021 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package overlay
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"io"
+
+	"golang.org/x/sys/unix"
+	"gvisor.dev/gvisor/pkg/abi/linux"
+	"gvisor.dev/gvisor/pkg/context"
+	"gvisor.dev/gvisor/pkg/fd"
+	"gvisor.dev/gvisor/pkg/fspath"
+	"gvisor.dev/gvisor/pkg/log"
+	"gvisor.dev/gvisor/pkg/sentry/kernel/auth"
+	"gvisor.dev/gvisor/pkg/sentry/vfs"
+	"gvisor.dev/gvisor/pkg/sync"
+	"gvisor.dev/gvisor/pkg/syserror"
+	"gvisor.dev/gvisor/pkg/usermem"
+)
+
+// Layout of upper store files.
+//
+// The file starts with two header slots. Each header points to a snapshot of
+// the upper layer elsewhere in the file. Snapshots are written to a region of
+// the file that doesn't overlap the current snapshot, and are committed by
+// writing a header with a higher generation to the slot that doesn't hold the
+// current header. A torn write can therefore only affect data that isn't
+// referenced by the current header.
+const (
+	upperStoreMagic      = 0x3172657070557667 // "gvUpper1"
+	upperStoreHeaderSize = 512
+	upperStoreSlots      = 2
+	upperStoreDataStart  = 4096
+
+	// upperStoreHeaderLen is the number of bytes of the header that are
+	// used: magic, generation, offset, length, data CRC and header CRC.
+	upperStoreHeaderLen = 40
+
+	// upperStoreBufSize is the size of buffers used for file I/O.
+	upperStoreBufSize = 64 << 10
+)
+
+// Kinds of records in snapshots. Each record describes a file in the upper
+// layer; parents precede their children.
+const (
+	upperRecordEnd      = 0
+	upperRecordDir      = 1
+	upperRecordRegular  = 2
+	upperRecordSymlink  = 3
+	upperRecordWhiteout = 4
+	upperRecordFIFO     = 5
+
+	// upperRecordEscapedWhiteout is a character device with device number
+	// 0/0 that is a file of the overlay rather than a whiteout.
+	upperRecordEscapedWhiteout = 6
+)
+
+var crc32cTable = crc32.MakeTable(crc32.Castagnoli)
+
+// upperStoreHeader is a header of an upper store file.
+type upperStoreHeader struct {
+	gen uint64
+	off uint64
+	len uint64
+	crc uint32
+}
+
+func (h *upperStoreHeader) encode() []byte {
+	b := make([]byte, upperStoreHeaderSize)
+	le := binary.LittleEndian
+	le.PutUint64(b[0:], upperStoreMagic)
+	le.PutUint64(b[8:], h.gen)
+	le.PutUint64(b[16:], h.off)
+	le.PutUint64(b[24:], h.len)
+	le.PutUint32(b[32:], h.crc)
+	le.PutUint32(b[36:], crc32.Checksum(b[:36], crc32cTable))
+	return b
+}
+
+// decode decodes a header. It returns false if the header is invalid.
+func (h *upperStoreHeader) decode(b []byte) bool {
+	le := binary.LittleEndian
+	if le.Uint64(b[0:]) != upperStoreMagic || le.Uint32(b[36:]) != crc32.Checksum(b[:36], crc32cTable) {
+		return false
+	}
+	h.gen = le.Uint64(b[8:])
+	h.off = le.Uint64(b[16:])
+	h.len = le.Uint64(b[24:])
+	h.crc = le.Uint32(b[32:])
+	return h.gen != 0 && h.off >= upperStoreDataStart
+}
+
+// UpperStore persists the upper layer of an overlay filesystem in a host
+// file, so that writes to the overlay survive restarts of the sandbox.
+//
+// The upper layer is loaded from the file's latest snapshot when the overlay
+// is created, and snapshots are written by Flush. Regular files, directories,
+// symbolic links, named pipes, whiteouts and opaque directories are
+// persisted, along with their permissions, ownership and timestamps. Hard
+// links are persisted as separate files, and other file types are dropped.
+type UpperStore struct {
+	// mu serializes operations on the store.
+	mu sync.Mutex
+
+	// file is the host file. file is immutable.
+	file *fd.FD
+
+	// cur is the header of the current snapshot. cur.gen is 0 if the file
+	// holds no snapshot.
+	cur upperStoreHeader
+
+	// root is the root of the upper layer, once attached by Load.
+	root vfs.VirtualDentry
+
+	// creds are used to access the upper layer.
+	creds *auth.Credentials
+}
+
+// NewUpperStore returns an UpperStore that persists an upper layer in the
+// given host file. The UpperStore takes ownership of file.
+func NewUpperStore(file *fd.FD) (*UpperStore, error) {
+	s := &UpperStore{file: file}
+	var stat unix.Stat_t
+	if err := unix.Fstat(file.FD(), &stat); err != nil {
+		return nil, err
+	}
+	buf := make([]byte, upperStoreHeaderSize*upperStoreSlots)
+	n, err := file.ReadAt(buf, 0)
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	buf = buf[:n]
+
+	// Use the latest snapshot whose data is intact.
+	var hdrs []upperStoreHeader
+	for slot := 0; (slot+1)*upperStoreHeaderSize <= len(buf); slot++ {
+		var h upperStoreHeader
+		if h.decode(buf[slot*upperStoreHeaderSize:]) && h.off+h.len <= uint64(stat.Size) {
+			hdrs = append(hdrs, h)
+		}
+	}
+	if len(hdrs) == 2 && hdrs[1].gen > hdrs[0].gen {
+		hdrs[0], hdrs[1] = hdrs[1], hdrs[0]
+	}
+	for _, h := range hdrs {
+		crc, err := s.checksum(h.off, h.len)
+		if err != nil {
+			return nil, err
+		}
+		if crc == h.crc {
+			s.cur = h
+			break
+		}
+		log.Warningf("Discarding corrupted overlay upper layer snapshot (generation %d)", h.gen)
+	}
+	return s, nil
+}
+
+// checksum returns the CRC of the given range of the file.
+func (s *UpperStore) checksum(off, length uint64) (uint32, error) {
+	h := crc32.New(crc32cTable)
+	if _, err := io.Copy(h, io.NewSectionReader(s.file, int64(off), int64(length))); err != nil {
+		return 0, err
+	}
+	return h.Sum32(), nil
+}
+
+// Load restores the latest snapshot into root, which must be the root of an
+// empty directory, and attaches root to the store so that it is written by
+// later calls to Flush. creds must be able to set the ownership and trusted
+// extended attributes of files in root.
+func (s *UpperStore) Load(ctx context.Context, creds *auth.Credentials, root vfs.VirtualDentry) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.root.Ok() {
+		return fmt.Errorf("overlay upper store is already attached")
+	}
+	if s.cur.gen != 0 {
+		r := bufio.NewReaderSize(io.NewSectionReader(s.file, int64(s.cur.off), int64(s.cur.len)), upperStoreBufSize)
+		if err := loadUpperLayer(ctx, creds, root, r); err != nil {
+			return fmt.Errorf("loading overlay upper layer snapshot (generation %d): %w", s.cur.gen, err)
+		}
+	}
+	root.IncRef()
+	s.root = root
+	s.creds = creds
+	return nil
+}
+
+// Flush writes a snapshot of the attached upper layer. The snapshot is only
+// consistent if the upper layer isn't modified concurrently, so callers
+// should pause the sandbox or flush after its applications have exited.
+func (s *UpperStore) Flush(ctx context.Context) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.root.Ok() {
+		return nil
+	}
+
+	// Write the snapshot after the current one.
+	h := upperStoreHeader{
+		gen: s.cur.gen + 1,
+		off: upperStoreDataStart,
+	}
+	if s.cur.gen != 0 {
+		h.off = alignUp(s.cur.off+s.cur.len, upperStoreDataStart)
+	}
+	w := &upperStoreWriter{
+		file: s.file,
+		off:  int64(h.off),
+		crc:  crc32.New(crc32cTable),
+	}
+	bw := bufio.NewWriterSize(w, upperStoreBufSize)
+	if err := saveUpperLayer(ctx, s.creds, s.root, bw); err != nil {
+		return fmt.Errorf("writing overlay upper layer snapshot: %w", err)
+	}
+	if err := bw.Flush(); err != nil {
+		return fmt.Errorf("writing overlay upper layer snapshot: %w", err)
+	}
+	h.len = uint64(w.off) - h.off
+	h.crc = w.crc.Sum32()
+	if err := s.commit(h); err != nil {
+		return err
+	}
+
+	// Move the snapshot to the start of the data area if it fits before its
+	// current location, so that the file doesn't keep growing.
+	if h.off == upperStoreDataStart || h.len > h.off-upperStoreDataStart {
+		return nil
+	}
+	buf := make([]byte, upperStoreBufSize)
+	for done := uint64(0); done < h.len; {
+		n := uint64(len(buf))
+		if rem := h.len - done; n > rem {
+			n = rem
+		}
+		if _, err := s.file.ReadAt(buf[:n], int64(h.off+done)); err != nil {
+			return fmt.Errorf("compacting overlay upper layer snapshot: %w", err)
+		}
+		if _, err := s.file.WriteAt(buf[:n], int64(upperStoreDataStart+done)); err != nil {
+			return fmt.Errorf("compacting overlay upper layer snapshot: %w", err)
+		}
+		done += n
+	}
+	h.gen++
+	h.off = upperStoreDataStart
+	if err := s.commit(h); err != nil {
+		return err
+	}
+	if err := unix.Ftruncate(s.file.FD(), int64(h.off+h.len)); err != nil {
+		log.Warningf("Failed to truncate overlay upper store: %v", err)
+	}
+	return nil
+}
+
+// commit makes the snapshot described by h current.
+//
+// Preconditions: s.mu is locked. The snapshot's data has been written.
+func (s *UpperStore) commit(h upperStoreHeader) error {
+	if err := unix.Fsync(s.file.FD()); err != nil {
+		return fmt.Errorf("syncing overlay upper layer snapshot: %w", err)
+	}
+	slot := int64(h.gen%upperStoreSlots) * upperStoreHeaderSize
+	if _, err := s.file.WriteAt(h.encode(), slot); err != nil {
+		return fmt.Errorf("writing overlay upper layer snapshot header: %w", err)
+	}
+	if err := unix.Fsync(s.file.FD()); err != nil {
+		return fmt.Errorf("syncing overlay upper layer snapshot header: %w", err)
+	}
+	s.cur = h
+	return nil
+}
+
+// Release detaches the upper layer and closes the host file. It doesn't flush
+// the upper layer.
+func (s *UpperStore) Release(ctx context.Context) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.root.Ok() {
+		s.root.DecRef(ctx)
+		s.root = vfs.VirtualDentry{}
+	}
+	s.file.Close()
+}
+
+func alignUp(x, align uint64) uint64 {
+	return (x + align - 1) &^ (align - 1)
+}
+
+// upperStoreWriter writes sequentially to the host file, computing the CRC of
+// the written data.
+type upperStoreWriter struct {
+	file *fd.FD
+	off  int64
+	crc  hash.Hash32
+}
+
+// Write implements io.Writer.Write.
+func (w *upperStoreWriter) Write(b []byte) (int, error) {
+	n, err := w.file.WriteAt(b, w.off)
+	w.off += int64(n)
+	w.crc.Write(b[:n])
+	return n, err
+}
+
+// upperRecord is the fixed part of a snapshot record.
+type upperRecord struct {
+	Mode      uint16
+	UID       uint32
+	GID       uint32
+	AtimeSec  int64
+	AtimeNsec uint32
+	MtimeSec  int64
+	MtimeNsec uint32
+}
+
+func writeString(w io.Writer, s string) error {
+	if len(s) > 0xffff {
+		return syserror.ENAMETOOLONG
+	}
+	if err := binary.Write(w, binary.LittleEndian, uint16(len(s))); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, s)
+	return err
+}
+
+func readString(r io.Reader) (string, error) {
+	var n uint16
+	if err := binary.Read(r, binary.LittleEndian, &n); err != nil {
+		return "", err
+	}
+	b := make([]byte, n)
+	if _, err := io.ReadFull(r, b); err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
+
+// saveUpperLayer writes the snapshot records of the tree at root to w.
+func saveUpperLayer(ctx context.Context, creds *auth.Credentials, root vfs.VirtualDentry, w io.Writer) error {
+	vfsObj := root.Mount().Filesystem().VirtualFilesystem()
+	buf := make([]byte, upperStoreBufSize)
+	var saveFile func(path string) error
+	saveFile = func(path string) error {
+		pop := &vfs.PathOperation{
+			Root:  root,
+			Start: root,
+			Path:  fspath.Parse(path),
+		}
+		stat, err := vfsObj.StatAt(ctx, creds, pop, &vfs.StatOptions{
+			Mask: linux.STATX_TYPE | linux.STATX_MODE | linux.STATX_UID | linux.STATX_GID | linux.STATX_SIZE | linux.STATX_ATIME | linux.STATX_MTIME,
+		})
+		if err != nil {
+			return err
+		}
+		var kind uint8
+		switch stat.Mode & linux.S_IFMT {
+		case linux.S_IFDIR:
+			kind = upperRecordDir
+		case linux.S_IFREG:
+			kind = upperRecordRegular
+		case linux.S_IFLNK:
+			kind = upperRecordSymlink
+		case linux.S_IFIFO:
+			kind = upperRecordFIFO
+		case linux.S_IFCHR:
+			if isWhiteout(&stat) {
+				kind = upperRecordWhiteout
+				escaped, err := vfsObj.GetXattrAt(ctx, creds, pop, &vfs.GetXattrOptions{
+					Name: _OVL_XATTR_ESCAPED_WHITEOUT,
+					Size: 1,
+				})
+				if err != nil && err != syserror.ENODATA {
+					return err
+				}
+				if escaped == "y" {
+					kind = upperRecordEscapedWhiteout
+				}
+				break
+			}
+			fallthrough
+		default:
+			log.Warningf("Not persisting overlay upper layer file %q with mode %#o", path, stat.Mode)
+			return nil
+		}
+		if err := binary.Write(w, binary.LittleEndian, kind); err != nil {
+			return err
+		}
+		if err := writeString(w, path); err != nil {
+			return err
+		}
+		if err := binary.Write(w, binary.LittleEndian, &upperRecord{
+			Mode:      stat.Mode &^ linux.S_IFMT,
+			UID:       stat.UID,
+			GID:       stat.GID,
+			AtimeSec:  stat.Atime.Sec,
+			AtimeNsec: stat.Atime.Nsec,
+			MtimeSec:  stat.Mtime.Sec,
+			MtimeNsec: stat.Mtime.Nsec,
+		}); err != nil {
+			return err
+		}
+
+		switch kind {
+		case upperRecordDir:
+			opaque, err := vfsObj.GetXattrAt(ctx, creds, pop, &vfs.GetXattrOptions{
+				Name: _OVL_XATTR_OPAQUE,
+				Size: 1,
+			})
+			if err != nil && err != syserror.ENODATA {
+				return err
+			}
+			var isOpaque uint8
+			if opaque == "y" {
+				isOpaque = 1
+			}
+			if err := binary.Write(w, binary.LittleEndian, isOpaque); err != nil {
+				return err
+			}
+			dirFD, err := vfsObj.OpenAt(ctx, creds, pop, &vfs.OpenOptions{
+				Flags: linux.O_RDONLY | linux.O_DIRECTORY,
+			})
+			if err != nil {
+				return err
+			}
+			var names []string
+			err = dirFD.IterDirents(ctx, vfs.IterDirentsCallbackFunc(func(dirent vfs.Dirent) error {
+				if dirent.Name != "." && dirent.Name != ".." {
+					names = append(names, dirent.Name)
+				}
+				return nil
+			}))
+			dirFD.DecRef(ctx)
+			if err != nil {
+				return err
+			}
+			for _, name := range names {
+				childPath := name
+				if path != "" {
+					childPath = path + "/" + name
+				}
+				if err := saveFile(childPath); err != nil {
+					return err
+				}
+			}
+
+		case upperRecordRegular:
+			if err := binary.Write(w, binary.LittleEndian, stat.Size); err != nil {
+				return err
+			}
+			fileFD, err := vfsObj.OpenAt(ctx, creds, pop, &vfs.OpenOptions{
+				Flags: linux.O_RDONLY,
+			})
+			if err != nil {
+				return err
+			}
+			defer fileFD.DecRef(ctx)
+			// Write exactly stat.Size bytes, even if the file changed size
+			// since it was stat'ed.
+			for done := uint64(0); done < stat.Size; {
+				n := uint64(len(buf))
+				if rem := stat.Size - done; n > rem {
+					n = rem
+				}
+				read, err := fileFD.PRead(ctx, usermem.BytesIOSequence(buf[:n]), int64(done), vfs.ReadOptions{})
+				if err != nil && err != io.EOF {
+					return err
+				}
+				if read == 0 {
+					for i := range buf[:n] {
+						buf[i] = 0
+					}
+					read = int64(n)
+				}
+				if _, err := w.Write(buf[:read]); err != nil {
+					return err
+				}
+				done += uint64(read)
+			}
+
+		case upperRecordSymlink:
+			target, err := vfsObj.ReadlinkAt(ctx, creds, pop)
+			if err != nil {
+				return err
+			}
+			if err := writeString(w, target); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	if err := saveFile(""); err != nil {
+		return err
+	}
+	return binary.Write(w, binary.LittleEndian, uint8(upperRecordEnd))
+}
+
+// loadUpperLayer recreates the tree described by the snapshot records in r
+// at root.
+func loadUpperLayer(ctx context.Context, creds *auth.Credentials, root vfs.VirtualDentry, r io.Reader) error {
+	vfsObj := root.Mount().Filesystem().VirtualFilesystem()
+	buf := make([]byte, upperStoreBufSize)
+
+	// Directory timestamps are restored last, since creating their children
+	// modifies them.
+	type dirAttrs struct {
+		pop *vfs.PathOperation
+		rec upperRecord
+	}
+	var dirs []dirAttrs
+
+	setStat := func(pop *vfs.PathOperation, rec *upperRecord) error {
+		return vfsObj.SetStatAt(ctx, creds, pop, &vfs.SetStatOptions{
+			Stat: linux.Statx{
+				Mask:  linux.STATX_MODE | linux.STATX_UID | linux.STATX_GID | linux.STATX_ATIME | linux.STATX_MTIME,
+				Mode:  rec.Mode,
+				UID:   rec.UID,
+				GID:   rec.GID,
+				Atime: linux.StatxTimestamp{Sec: rec.AtimeSec, Nsec: rec.AtimeNsec},
+				Mtime: linux.StatxTimestamp{Sec: rec.MtimeSec, Nsec: rec.MtimeNsec},
+			},
+		})
+	}
+
+	for {
+		var kind uint8
+		if err := binary.Read(r, binary.LittleEndian, &kind); err != nil {
+			return err
+		}
+		if kind == upperRecordEnd {
+			break
+		}
+		path, err := readString(r)
+		if err != nil {
+			return err
+		}
+		var rec upperRecord
+		if err := binary.Read(r, binary.LittleEndian, &rec); err != nil {
+			return err
+		}
+		pop := &vfs.PathOperation{
+			Root:  root,
+			Start: root,
+			Path:  fspath.Parse(path),
+		}
+		// Files are created with restrictive permissions until their
+		// attributes are restored.
+		switch kind {
+		case upperRecordDir:
+			var opaque uint8
+			if err := binary.Read(r, binary.LittleEndian, &opaque); err != nil {
+				return err
+			}
+			if path != "" {
+				if err := vfsObj.MkdirAt(ctx, creds, pop, &vfs.MkdirOptions{Mode: 0700}); err != nil {
+					return err
+				}
+				if opaque != 0 {
+					if err := vfsObj.SetXattrAt(ctx, creds, pop, &vfs.SetXattrOptions{
+						Name:  _OVL_XATTR_OPAQUE,
+						Value: "y",
+					}); err != nil {
+						return err
+					}
+				}
+			}
+			dirs = append(dirs, dirAttrs{pop, rec})
+			continue
+
+		case upperRecordRegular:
+			var size uint64
+			if err := binary.Read(r, binary.LittleEndian, &size); err != nil {
+				return err
+			}
+			fileFD, err := vfsObj.OpenAt(ctx, creds, pop, &vfs.OpenOptions{
+				Flags: linux.O_WRONLY | linux.O_CREAT | linux.O_EXCL,
+				Mode:  0600,
+			})
+			if err != nil {
+				return err
+			}
+			for done := uint64(0); done < size; {
+				n := uint64(len(buf))
+				if rem := size - done; n > rem {
+					n = rem
+				}
+				if _, err := io.ReadFull(r, buf[:n]); err != nil {
+					fileFD.DecRef(ctx)
+					return err
+				}
+				if _, err := fileFD.PWrite(ctx, usermem.BytesIOSequence(buf[:n]), int64(done), vfs.WriteOptions{}); err != nil {
+					fileFD.DecRef(ctx)
+					return err
+				}
+				done += n
+			}
+			fileFD.DecRef(ctx)
+
+		case upperRecordSymlink:
+			target, err := readString(r)
+			if err != nil {
+				return err
+			}
+			if err := vfsObj.SymlinkAt(ctx, creds, pop, target); err != nil {
+				return err
+			}
+
+		case upperRecordWhiteout, upperRecordEscapedWhiteout:
+			if err := vfsObj.MknodAt(ctx, creds, pop, &vfs.MknodOptions{
+				Mode: linux.S_IFCHR,
+			}); err != nil {
+				return err
+			}
+			if kind == upperRecordEscapedWhiteout {
+				if err := vfsObj.SetXattrAt(ctx, creds, pop, &vfs.SetXattrOptions{
+					Name:  _OVL_XATTR_ESCAPED_WHITEOUT,
+					Value: "y",
+				}); err != nil {
+					return err
+				}
+			}
+
+		case upperRecordFIFO:
+			if err := vfsObj.MknodAt(ctx, creds, pop, &vfs.MknodOptions{
+				Mode: linux.S_IFIFO | 0600,
+			}); err != nil {
+				return err
+			}
+
+		default:
+			return fmt.Errorf("unknown record kind %d", kind)
+		}
+
+		if kind == upperRecordSymlink || kind == upperRecordWhiteout {
+			// Symbolic links and whiteouts only have their ownership
+			// restored; their mode is fixed.
+			if err := vfsObj.SetStatAt(ctx, creds, pop, &vfs.SetStatOptions{
+				Stat: linux.Statx{
+					Mask: linux.STATX_UID | linux.STATX_GID,
+					UID:  rec.UID,
+					GID:  rec.GID,
+				},
+			}); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := setStat(pop, &rec); err != nil {
+			return err
+		}
+	}
+
+	for i := len(dirs) - 1; i >= 0; i-- {
+		if err := setStat(dirs[i].pop, &dirs[i].rec); err != nil {
+			return err
+		}
+	}
+	return nil
+}
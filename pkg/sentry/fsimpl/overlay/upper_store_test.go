@@ -0,0 +1,601 @@
+// Copyright 2021 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package overlay
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"gvisor.dev/gvisor/pkg/abi/linux"
+	"gvisor.dev/gvisor/pkg/context"
+	"gvisor.dev/gvisor/pkg/fd"
+	"gvisor.dev/gvisor/pkg/fspath"
+	"gvisor.dev/gvisor/pkg/sentry/contexttest"
+	"gvisor.dev/gvisor/pkg/sentry/fsimpl/tmpfs"
+	"gvisor.dev/gvisor/pkg/sentry/kernel/auth"
+	"gvisor.dev/gvisor/pkg/sentry/vfs"
+	"gvisor.dev/gvisor/pkg/syserror"
+	"gvisor.dev/gvisor/pkg/usermem"
+)
+
+// upperStoreTest holds the state shared by the upper layers of a test.
+type upperStoreTest struct {
+	t      *testing.T
+	ctx    context.Context
+	creds  *auth.Credentials
+	vfsObj *vfs.VirtualFilesystem
+
+	// file is the host file in which upper layers are persisted.
+	file *os.File
+}
+
+func newUpperStoreTest(t *testing.T) *upperStoreTest {
+	t.Helper()
+	ctx := contexttest.RootContext(t)
+	vfsObj := &vfs.VirtualFilesystem{}
+	if err := vfsObj.Init(ctx); err != nil {
+		t.Fatalf("VFS init: %v", err)
+	}
+	vfsObj.MustRegisterFilesystemType("tmpfs", tmpfs.FilesystemType{}, &vfs.RegisterFilesystemTypeOptions{
+		AllowUserMount: true,
+	})
+	file, err := ioutil.TempFile("", "upper_store_test")
+	if err != nil {
+		t.Fatalf("TempFile failed: %v", err)
+	}
+	t.Cleanup(func() {
+		file.Close()
+		os.Remove(file.Name())
+	})
+	return &upperStoreTest{
+		t:      t,
+		ctx:    ctx,
+		creds:  auth.CredentialsFromContext(ctx),
+		vfsObj: vfsObj,
+		file:   file,
+	}
+}
+
+// newRoot returns the root of a new, empty tmpfs upper layer.
+func (ut *upperStoreTest) newRoot() vfs.VirtualDentry {
+	ut.t.Helper()
+	mnt, err := ut.vfsObj.MountDisconnected(ut.ctx, ut.creds, "" /* source */, "tmpfs", &vfs.MountOptions{})
+	if err != nil {
+		ut.t.Fatalf("failed to mount tmpfs: %v", err)
+	}
+	ut.t.Cleanup(func() { mnt.DecRef(ut.ctx) })
+	return vfs.MakeVirtualDentry(mnt, mnt.Root())
+}
+
+// newStore returns an UpperStore for the test's host file.
+func (ut *upperStoreTest) newStore() *UpperStore {
+	ut.t.Helper()
+	f, err := fd.NewFromFile(ut.file)
+	if err != nil {
+		ut.t.Fatalf("NewFromFile failed: %v", err)
+	}
+	s, err := NewUpperStore(f)
+	if err != nil {
+		ut.t.Fatalf("NewUpperStore failed: %v", err)
+	}
+	return s
+}
+
+// attach restores the latest snapshot in the host file into the upper layer
+// at root, and returns a store attached to it.
+func (ut *upperStoreTest) attach(root vfs.VirtualDentry) *UpperStore {
+	ut.t.Helper()
+	s := ut.newStore()
+	ut.t.Cleanup(func() { s.Release(ut.ctx) })
+	if err := s.Load(ut.ctx, ut.creds, root); err != nil {
+		ut.t.Fatalf("Load failed: %v", err)
+	}
+	return s
+}
+
+// load restores the latest snapshot in the host file into a new upper layer
+// and returns its root and a store attached to it.
+func (ut *upperStoreTest) load() (vfs.VirtualDentry, *UpperStore) {
+	ut.t.Helper()
+	root := ut.newRoot()
+	return root, ut.attach(root)
+}
+
+func (ut *upperStoreTest) flush(s *UpperStore) {
+	ut.t.Helper()
+	if err := s.Flush(ut.ctx); err != nil {
+		ut.t.Fatalf("Flush failed: %v", err)
+	}
+}
+
+func (ut *upperStoreTest) pop(root vfs.VirtualDentry, path string) *vfs.PathOperation {
+	return &vfs.PathOperation{
+		Root:  root,
+		Start: root,
+		Path:  fspath.Parse(path),
+	}
+}
+
+// upperFile describes a file of an upper layer.
+type upperFile struct {
+	path    string
+	mode    linux.FileMode
+	uid     uint32
+	gid     uint32
+	data    string
+	target  string
+	opaque  bool
+	escaped bool
+}
+
+// mtime returns the modification time of the file at path.
+func (f *upperFile) mtime() int64 {
+	return int64(crc32.ChecksumIEEE([]byte(f.path)))
+}
+
+// build creates files in the upper layer at root.
+func (ut *upperStoreTest) build(root vfs.VirtualDentry, files []upperFile) {
+	ut.t.Helper()
+	for _, f := range files {
+		pop := ut.pop(root, f.path)
+		var err error
+		switch f.mode.FileType() {
+		case linux.ModeDirectory:
+			err = ut.vfsObj.MkdirAt(ut.ctx, ut.creds, pop, &vfs.MkdirOptions{Mode: f.mode.Permissions()})
+			if err == nil && f.opaque {
+				err = ut.vfsObj.SetXattrAt(ut.ctx, ut.creds, pop, &vfs.SetXattrOptions{
+					Name:  _OVL_XATTR_OPAQUE,
+					Value: "y",
+				})
+			}
+		case linux.ModeRegular:
+			var fileFD *vfs.FileDescription
+			fileFD, err = ut.vfsObj.OpenAt(ut.ctx, ut.creds, pop, &vfs.OpenOptions{
+				Flags: linux.O_WRONLY | linux.O_CREAT | linux.O_EXCL,
+				Mode:  f.mode.Permissions(),
+			})
+			if err == nil {
+				_, err = fileFD.Write(ut.ctx, usermem.BytesIOSequence([]byte(f.data)), vfs.WriteOptions{})
+				fileFD.DecRef(ut.ctx)
+			}
+		case linux.ModeSymlink:
+			err = ut.vfsObj.SymlinkAt(ut.ctx, ut.creds, pop, f.target)
+		case linux.ModeCharacterDevice, linux.ModeNamedPipe:
+			err = ut.vfsObj.MknodAt(ut.ctx, ut.creds, pop, &vfs.MknodOptions{Mode: f.mode})
+			if err == nil && f.escaped {
+				err = ut.vfsObj.SetXattrAt(ut.ctx, ut.creds, pop, &vfs.SetXattrOptions{
+					Name:  _OVL_XATTR_ESCAPED_WHITEOUT,
+					Value: "y",
+				})
+			}
+		}
+		if err == nil {
+			err = ut.vfsObj.SetStatAt(ut.ctx, ut.creds, pop, &vfs.SetStatOptions{
+				Stat: linux.Statx{
+					Mask: linux.STATX_UID | linux.STATX_GID,
+					UID:  f.uid,
+					GID:  f.gid,
+				},
+			})
+		}
+		if err != nil {
+			ut.t.Fatalf("failed to create %q: %v", f.path, err)
+		}
+	}
+
+	// Set modification times once all files exist, since creating files
+	// changes the modification time of their parents.
+	for _, f := range files {
+		if f.mode.FileType() == linux.ModeSymlink || (f.mode.FileType() == linux.ModeCharacterDevice && !f.escaped) {
+			continue
+		}
+		if err := ut.vfsObj.SetStatAt(ut.ctx, ut.creds, ut.pop(root, f.path), &vfs.SetStatOptions{
+			Stat: linux.Statx{
+				Mask:  linux.STATX_MTIME,
+				Mtime: linux.StatxTimestamp{Sec: f.mtime(), Nsec: 1},
+			},
+		}); err != nil {
+			ut.t.Fatalf("failed to set modification time of %q: %v", f.path, err)
+		}
+	}
+}
+
+// check checks that the upper layer at root consists of files.
+func (ut *upperStoreTest) check(root vfs.VirtualDentry, files []upperFile) {
+	ut.t.Helper()
+	want := map[string]bool{"": true}
+	for _, f := range files {
+		want[f.path] = true
+		pop := ut.pop(root, f.path)
+		stat, err := ut.vfsObj.StatAt(ut.ctx, ut.creds, pop, &vfs.StatOptions{Mask: linux.STATX_BASIC_STATS})
+		if err != nil {
+			ut.t.Errorf("stat %q failed: %v", f.path, err)
+			continue
+		}
+		wantMode := f.mode
+		if f.mode.FileType() == linux.ModeSymlink {
+			// The mode of symbolic links is fixed.
+			wantMode = linux.ModeSymlink | 0777
+		}
+		if got := linux.FileMode(stat.Mode); got != wantMode {
+			ut.t.Errorf("%q has mode %#o, want %#o", f.path, got, wantMode)
+		}
+		if stat.UID != f.uid || stat.GID != f.gid {
+			ut.t.Errorf("%q is owned by %d:%d, want %d:%d", f.path, stat.UID, stat.GID, f.uid, f.gid)
+		}
+		switch f.mode.FileType() {
+		case linux.ModeDirectory:
+			if got := ut.getXattr(pop, _OVL_XATTR_OPAQUE); got != f.opaque {
+				ut.t.Errorf("%q is opaque: %t, want %t", f.path, got, f.opaque)
+			}
+		case linux.ModeRegular:
+			if got := ut.readFile(pop); got != f.data {
+				ut.t.Errorf("%q has %d bytes of data, want %d bytes", f.path, len(got), len(f.data))
+			}
+		case linux.ModeSymlink:
+			if target, err := ut.vfsObj.ReadlinkAt(ut.ctx, ut.creds, pop); err != nil || target != f.target {
+				ut.t.Errorf("readlink %q = %q, %v, want %q", f.path, target, err, f.target)
+			}
+			continue
+		case linux.ModeCharacterDevice:
+			if !isWhiteout(&stat) {
+				ut.t.Errorf("%q has device number %d/%d, want whiteout", f.path, stat.RdevMajor, stat.RdevMinor)
+			}
+			if got := ut.getXattr(pop, _OVL_XATTR_ESCAPED_WHITEOUT); got != f.escaped {
+				ut.t.Errorf("%q is an escaped whiteout: %t, want %t", f.path, got, f.escaped)
+			}
+			if !f.escaped {
+				continue
+			}
+		}
+		if got := (linux.StatxTimestamp{Sec: f.mtime(), Nsec: 1}); stat.Mtime != got {
+			ut.t.Errorf("%q has modification time %+v, want %+v", f.path, stat.Mtime, got)
+		}
+	}
+
+	// Check that there are no other files.
+	var walk func(path string)
+	walk = func(path string) {
+		if !want[path] {
+			ut.t.Errorf("unexpected file %q", path)
+			return
+		}
+		dirFD, err := ut.vfsObj.OpenAt(ut.ctx, ut.creds, ut.pop(root, path), &vfs.OpenOptions{
+			Flags: linux.O_RDONLY | linux.O_DIRECTORY,
+		})
+		if err != nil {
+			// Not a directory.
+			return
+		}
+		var names []string
+		err = dirFD.IterDirents(ut.ctx, vfs.IterDirentsCallbackFunc(func(dirent vfs.Dirent) error {
+			if dirent.Name != "." && dirent.Name != ".." {
+				names = append(names, dirent.Name)
+			}
+			return nil
+		}))
+		dirFD.DecRef(ut.ctx)
+		if err != nil {
+			ut.t.Errorf("getdents %q failed: %v", path, err)
+			return
+		}
+		for _, name := range names {
+			if path == "" {
+				walk(name)
+			} else {
+				walk(path + "/" + name)
+			}
+		}
+	}
+	walk("")
+}
+
+func (ut *upperStoreTest) getXattr(pop *vfs.PathOperation, name string) bool {
+	ut.t.Helper()
+	val, err := ut.vfsObj.GetXattrAt(ut.ctx, ut.creds, pop, &vfs.GetXattrOptions{
+		Name: name,
+		Size: 1,
+	})
+	if err != nil && err != syserror.ENODATA {
+		ut.t.Errorf("getxattr %s failed: %v", name, err)
+	}
+	return val == "y"
+}
+
+func (ut *upperStoreTest) readFile(pop *vfs.PathOperation) string {
+	ut.t.Helper()
+	fileFD, err := ut.vfsObj.OpenAt(ut.ctx, ut.creds, pop, &vfs.OpenOptions{Flags: linux.O_RDONLY})
+	if err != nil {
+		ut.t.Errorf("open failed: %v", err)
+		return ""
+	}
+	defer fileFD.DecRef(ut.ctx)
+	buf := make([]byte, 1<<20)
+	n, err := fileFD.Read(ut.ctx, usermem.BytesIOSequence(buf), vfs.ReadOptions{})
+	if err != nil && err != io.EOF {
+		ut.t.Errorf("read failed: %v", err)
+	}
+	return string(buf[:n])
+}
+
+// commit writes a header for a snapshot with the given data to the host file.
+func (ut *upperStoreTest) commit(gen uint64, data []byte) {
+	ut.t.Helper()
+	h := upperStoreHeader{
+		gen: gen,
+		off: upperStoreDataStart,
+		len: uint64(len(data)),
+		crc: crc32.Checksum(data, crc32cTable),
+	}
+	if err := ut.file.Truncate(int64(h.off + h.len)); err != nil {
+		ut.t.Fatalf("Truncate failed: %v", err)
+	}
+	if _, err := ut.file.WriteAt(data, int64(h.off)); err != nil {
+		ut.t.Fatalf("WriteAt failed: %v", err)
+	}
+	if _, err := ut.file.WriteAt(h.encode(), headerSlot(h.gen)); err != nil {
+		ut.t.Fatalf("WriteAt failed: %v", err)
+	}
+}
+
+// headerSlot returns the offset of the header slot of the snapshot with the
+// given generation.
+func headerSlot(gen uint64) int64 {
+	return int64(gen%upperStoreSlots) * upperStoreHeaderSize
+}
+
+// smallLayer has a file of every kind that is persisted.
+var smallLayer = []upperFile{
+	{path: "dir", mode: linux.ModeDirectory | 0750, uid: 1, gid: 2},
+	{path: "dir/opaque", mode: linux.ModeDirectory | 0700, opaque: true},
+	{path: "dir/opaque/file", mode: linux.ModeRegular | 0640, uid: 3, gid: 4, data: "hello"},
+	{path: "empty", mode: linux.ModeRegular | 0600},
+	{path: "link", mode: linux.ModeSymlink, uid: 5, gid: 6, target: "dir/opaque/file"},
+	{path: "whiteout", mode: linux.ModeCharacterDevice, uid: 7, gid: 8},
+	{path: "escaped", mode: linux.ModeCharacterDevice | 0644, escaped: true},
+	{path: "fifo", mode: linux.ModeNamedPipe | 0620, uid: 9, gid: 10},
+}
+
+// bigFile is a file that spans several I/O buffers.
+var bigFile = upperFile{
+	path: "big",
+	mode: linux.ModeRegular | 0644,
+	data: string(bytes.Repeat([]byte("0123456789abcdef"), 3*upperStoreBufSize/16+1)),
+}
+
+func withFiles(layer []upperFile, files ...upperFile) []upperFile {
+	return append(append([]upperFile(nil), layer...), files...)
+}
+
+func TestUpperStoreRoundTrip(t *testing.T) {
+	for _, tc := range []struct {
+		name  string
+		files []upperFile
+	}{
+		{"empty", nil},
+		{"small", smallLayer},
+		{"big", withFiles(smallLayer, bigFile)},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			ut := newUpperStoreTest(t)
+			root, s := ut.load()
+			ut.build(root, tc.files)
+			ut.check(root, tc.files)
+			ut.flush(s)
+
+			root, _ = ut.load()
+			ut.check(root, tc.files)
+		})
+	}
+}
+
+func TestUpperStoreNoSnapshot(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		data []byte
+	}{
+		{"empty", nil},
+		{"garbage", bytes.Repeat([]byte{0xa5}, 2*upperStoreDataStart)},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			ut := newUpperStoreTest(t)
+			if _, err := ut.file.Write(tc.data); err != nil {
+				t.Fatalf("Write failed: %v", err)
+			}
+			root, s := ut.load()
+			ut.check(root, nil)
+
+			// The store is usable.
+			ut.build(root, smallLayer)
+			ut.flush(s)
+			root, _ = ut.load()
+			ut.check(root, smallLayer)
+		})
+	}
+}
+
+func TestUpperStoreCorruptNewerSnapshot(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		corrupt func(ut *upperStoreTest, h upperStoreHeader)
+	}{
+		{
+			name: "torn header",
+			corrupt: func(ut *upperStoreTest, h upperStoreHeader) {
+				// Only the start of the header was written.
+				hdr := h.encode()
+				for i := upperStoreHeaderLen / 2; i < len(hdr); i++ {
+					hdr[i] = 0
+				}
+				if _, err := ut.file.WriteAt(hdr, headerSlot(h.gen)); err != nil {
+					ut.t.Fatalf("WriteAt failed: %v", err)
+				}
+			},
+		},
+		{
+			name: "corrupt header",
+			corrupt: func(ut *upperStoreTest, h upperStoreHeader) {
+				hdr := h.encode()
+				hdr[16]++
+				if _, err := ut.file.WriteAt(hdr, headerSlot(h.gen)); err != nil {
+					ut.t.Fatalf("WriteAt failed: %v", err)
+				}
+			},
+		},
+		{
+			name: "corrupt data",
+			corrupt: func(ut *upperStoreTest, h upperStoreHeader) {
+				b := make([]byte, 1)
+				off := int64(h.off + h.len/2)
+				if _, err := ut.file.ReadAt(b, off); err != nil {
+					ut.t.Fatalf("ReadAt failed: %v", err)
+				}
+				b[0]++
+				if _, err := ut.file.WriteAt(b, off); err != nil {
+					ut.t.Fatalf("WriteAt failed: %v", err)
+				}
+			},
+		},
+		{
+			name: "truncated data",
+			corrupt: func(ut *upperStoreTest, h upperStoreHeader) {
+				if err := ut.file.Truncate(int64(h.off + h.len - 1)); err != nil {
+					ut.t.Fatalf("Truncate failed: %v", err)
+				}
+			},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			ut := newUpperStoreTest(t)
+			root, s := ut.load()
+			ut.build(root, smallLayer)
+			ut.flush(s)
+			// The newer snapshot is larger than the older one, so it
+			// isn't moved over it.
+			ut.build(root, []upperFile{bigFile})
+			ut.flush(s)
+			h := s.cur
+			if h.gen != 2 || h.off == upperStoreDataStart {
+				t.Fatalf("got snapshot generation %d at offset %d, want generation 2 after the older snapshot", h.gen, h.off)
+			}
+			root, _ = ut.load()
+			ut.check(root, withFiles(smallLayer, bigFile))
+
+			tc.corrupt(ut, h)
+			root, s = ut.load()
+			ut.check(root, smallLayer)
+
+			// The store is usable.
+			ut.flush(s)
+			root, _ = ut.load()
+			ut.check(root, smallLayer)
+		})
+	}
+}
+
+func TestUpperStoreCompaction(t *testing.T) {
+	ut := newUpperStoreTest(t)
+	root, s := ut.load()
+	ut.build(root, withFiles(smallLayer, bigFile))
+	ut.flush(s)
+
+	// The smaller snapshot fits before the bigger one, so it's moved to the
+	// start of the data area and the file is truncated after it.
+	if err := ut.vfsObj.UnlinkAt(ut.ctx, ut.creds, ut.pop(root, bigFile.path)); err != nil {
+		t.Fatalf("unlink failed: %v", err)
+	}
+	ut.flush(s)
+	h := s.cur
+	if h.gen != 3 || h.off != upperStoreDataStart {
+		t.Errorf("got snapshot generation %d at offset %d, want generation 3 at offset %d", h.gen, h.off, upperStoreDataStart)
+	}
+	fi, err := ut.file.Stat()
+	if err != nil {
+		t.Fatalf("Stat failed: %v", err)
+	}
+	if want := int64(h.off + h.len); fi.Size() != want {
+		t.Errorf("got file size %d, want %d", fi.Size(), want)
+	}
+	root, s = ut.load()
+	ut.check(root, smallLayer)
+
+	// Later snapshots build on the compacted one.
+	newFile := upperFile{path: "new", mode: linux.ModeRegular | 0600, data: "new"}
+	ut.build(root, []upperFile{newFile})
+	ut.flush(s)
+	root, _ = ut.load()
+	ut.check(root, withFiles(smallLayer, newFile))
+}
+
+func TestUpperStoreBadSnapshotData(t *testing.T) {
+	ut := newUpperStoreTest(t)
+	root := ut.newRoot()
+	ut.build(root, smallLayer)
+	var good bytes.Buffer
+	if err := saveUpperLayer(ut.ctx, ut.creds, root, &good); err != nil {
+		t.Fatalf("saveUpperLayer failed: %v", err)
+	}
+
+	var unknownKind bytes.Buffer
+	binary.Write(&unknownKind, binary.LittleEndian, uint8(upperRecordDir))
+	writeString(&unknownKind, "")
+	binary.Write(&unknownKind, binary.LittleEndian, &upperRecord{Mode: 0755})
+	binary.Write(&unknownKind, binary.LittleEndian, uint8(0))
+	binary.Write(&unknownKind, binary.LittleEndian, uint8(upperRecordEscapedWhiteout+1))
+	writeString(&unknownKind, "file")
+	binary.Write(&unknownKind, binary.LittleEndian, &upperRecord{})
+	binary.Write(&unknownKind, binary.LittleEndian, uint8(upperRecordEnd))
+
+	var hugeFile bytes.Buffer
+	binary.Write(&hugeFile, binary.LittleEndian, uint8(upperRecordRegular))
+	writeString(&hugeFile, "file")
+	binary.Write(&hugeFile, binary.LittleEndian, &upperRecord{Mode: 0644})
+	binary.Write(&hugeFile, binary.LittleEndian, uint64(1<<62))
+	hugeFile.WriteString("not nearly enough data")
+
+	type test struct {
+		name string
+		data []byte
+	}
+	tests := []test{
+		{"empty", nil},
+		{"garbage", bytes.Repeat([]byte{0xff}, 256)},
+		{"unknown kind", unknownKind.Bytes()},
+		{"huge file", hugeFile.Bytes()},
+	}
+	for n := 0; n < good.Len(); n++ {
+		tests = append(tests, test{fmt.Sprintf("truncated to %d bytes", n), good.Bytes()[:n]})
+	}
+	for _, tc := range tests {
+		// The snapshot's CRC is correct, so its records are loaded.
+		ut.commit(1, tc.data)
+		s := ut.newStore()
+		if err := s.Load(ut.ctx, ut.creds, ut.newRoot()); err == nil {
+			t.Errorf("%s: Load succeeded, want error", tc.name)
+		}
+		s.Release(ut.ctx)
+	}
+
+	// The complete snapshot loads.
+	ut.commit(1, good.Bytes())
+	root, _ = ut.load()
+	ut.check(root, smallLayer)
+}
@@ -84,7 +84,9 @@ func (fs *filesystem) newTaskInode(ctx context.Context, task *kernel.Task, pidns
 	if isThreadGroup {
 		contents["task"] = fs.newSubtasks(ctx, task, pidns, cgroupControllers)
 	}
-	if len(cgroupControllers) > 0 {
+	if task.Kernel().CgroupRoot() != nil {
+		contents["cgroup"] = fs.newTaskOwnedInode(ctx, task, fs.NextIno(), 0444, &cgroupData{task: task})
+	} else if len(cgroupControllers) > 0 {
 		contents["cgroup"] = fs.newTaskOwnedInode(ctx, task, fs.NextIno(), 0444, newCgroupData(cgroupControllers))
 	}
 
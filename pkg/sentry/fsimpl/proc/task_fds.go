@@ -327,11 +327,21 @@ func (d *fdInfoData) Generate(ctx context.Context, buf *bytes.Buffer) error {
 		return syserror.ENOENT
 	}
 	defer file.DecRef(ctx)
-	// TODO(b/121266871): Include pos, locks, and other data. For now we only
-	// have flags.
+	// TODO(b/121266871): Include locks.
 	// See https://www.kernel.org/doc/Documentation/filesystems/proc.txt
+	//
+	// Files without an offset show 0, as in Linux.
+	pos, err := file.Seek(ctx, 0, linux.SEEK_CUR)
+	if err != nil {
+		pos = 0
+	}
 	flags := uint(file.StatusFlags()) | descriptorFlags.ToLinuxFileFlags()
+	fmt.Fprintf(buf, "pos:\t%d\n", pos)
 	fmt.Fprintf(buf, "flags:\t0%o\n", flags)
+	fmt.Fprintf(buf, "mnt_id:\t%d\n", file.Mount().ID)
+	if ext, ok := file.Impl().(vfs.FileDescriptionImplFDInfoExtension); ok {
+		ext.FDInfo(ctx, buf)
+	}
 	return nil
 }
 
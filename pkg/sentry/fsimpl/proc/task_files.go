@@ -24,6 +24,7 @@ import (
 	"gvisor.dev/gvisor/pkg/safemem"
 	"gvisor.dev/gvisor/pkg/sentry/fsbridge"
 	"gvisor.dev/gvisor/pkg/sentry/fsimpl/kernfs"
+	"gvisor.dev/gvisor/pkg/sentry/inet"
 	"gvisor.dev/gvisor/pkg/sentry/kernel"
 	"gvisor.dev/gvisor/pkg/sentry/kernel/auth"
 	"gvisor.dev/gvisor/pkg/sentry/limits"
@@ -634,6 +635,29 @@ func (s *taskStatData) Generate(ctx context.Context, buf *bytes.Buffer) error {
 	return nil
 }
 
+// cgroupData implements vfs.DynamicBytesSource for /proc/[pid]/cgroup when
+// the cgroup v2 hierarchy is emulated. It has a single entry, for the unified
+// hierarchy, with ID 0 and no controllers.
+//
+// +stateify savable
+type cgroupData struct {
+	kernfs.DynamicBytesFile
+
+	task *kernel.Task
+}
+
+var _ dynamicInode = (*cgroupData)(nil)
+
+// Generate implements vfs.DynamicBytesSource.Generate.
+func (d *cgroupData) Generate(ctx context.Context, buf *bytes.Buffer) error {
+	path := "/"
+	if cg := d.task.ThreadGroup().Cgroup(); cg != nil {
+		path = cg.Path()
+	}
+	fmt.Fprintf(buf, "0::%s\n", path)
+	return nil
+}
+
 // statmData implements vfs.DynamicBytesSource for /proc/[pid]/statm.
 //
 // +stateify savable
@@ -991,6 +1015,9 @@ type namespaceSymlink struct {
 	kernfs.StaticSymlink
 
 	task *kernel.Task
+
+	// ns is the name of the namespace type, e.g. "net".
+	ns string
 }
 
 func (fs *filesystem) newNamespaceSymlink(ctx context.Context, task *kernel.Task, ino uint64, ns string) kernfs.Inode {
@@ -999,7 +1026,7 @@ func (fs *filesystem) newNamespaceSymlink(ctx context.Context, task *kernel.Task
 	// the inode number by sticking the symlink inode in its place.
 	target := fmt.Sprintf("%s:[%d]", ns, ino)
 
-	inode := &namespaceSymlink{task: task}
+	inode := &namespaceSymlink{task: task, ns: ns}
 	// Note: credentials are overridden by taskOwnedInode.
 	inode.Init(ctx, task.Credentials(), linux.UNNAMED_MAJOR, fs.devMinor, ino, target)
 
@@ -1024,6 +1051,9 @@ func (s *namespaceSymlink) Getlink(ctx context.Context, mnt *vfs.Mount) (vfs.Vir
 	// Create a synthetic inode to represent the namespace.
 	fs := mnt.Filesystem().Impl().(*filesystem)
 	nsInode := &namespaceInode{}
+	if s.ns == "net" {
+		nsInode.netns = s.task.NetworkNamespace()
+	}
 	nsInode.Init(ctx, auth.CredentialsFromContext(ctx), linux.UNNAMED_MAJOR, fs.devMinor, fs.NextIno(), 0444)
 	dentry := &kernfs.Dentry{}
 	dentry.Init(&fs.Filesystem, nsInode)
@@ -1045,6 +1075,10 @@ type namespaceInode struct {
 	kernfs.InodeNotSymlink
 
 	locks vfs.FileLocks
+
+	// netns is the network namespace represented by the inode. It is nil
+	// for other namespace types.
+	netns *inet.Namespace
 }
 
 var _ kernfs.Inode = (*namespaceInode)(nil)
@@ -1095,6 +1129,11 @@ func (fd *namespaceFD) SetStat(ctx context.Context, opts vfs.SetStatOptions) err
 	return fd.inode.SetStat(ctx, vfs, creds, opts)
 }
 
+// NetworkNamespace implements inet.NamespaceFile.NetworkNamespace.
+func (fd *namespaceFD) NetworkNamespace() *inet.Namespace {
+	return fd.inode.netns
+}
+
 // Release implements vfs.FileDescriptionImpl.Release.
 func (fd *namespaceFD) Release(ctx context.Context) {
 	fd.inode.DecRef(ctx)
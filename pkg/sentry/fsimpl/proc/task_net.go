@@ -18,7 +18,9 @@ import (
 	"bytes"
 	"fmt"
 	"io"
+	"net"
 	"reflect"
+	"strings"
 	"time"
 
 	"gvisor.dev/gvisor/pkg/abi/linux"
@@ -57,8 +59,9 @@ func (fs *filesystem) newTaskNetDir(ctx context.Context, task *kernel.Task) kern
 		// TODO(gvisor.dev/issue/1833): Make sure file contents reflect the task
 		// network namespace.
 		contents = map[string]kernfs.Inode{
-			"dev":  fs.newInode(ctx, root, 0444, &netDevData{stack: stack}),
-			"snmp": fs.newInode(ctx, root, 0444, &netSnmpData{stack: stack}),
+			"dev":          fs.newInode(ctx, root, 0444, &netDevData{stack: stack}),
+			"nf_conntrack": fs.newInode(ctx, root, 0440, &netConntrackData{stack: stack}),
+			"snmp":         fs.newInode(ctx, root, 0444, &netSnmpData{stack: stack}),
 
 			// The following files are simple stubs until they are implemented in
 			// netstack, if the file contains a header the stub is just the header
@@ -763,6 +766,86 @@ func (d *netRouteData) Generate(ctx context.Context, buf *bytes.Buffer) error {
 	return nil
 }
 
+// netConntrackData implements vfs.DynamicBytesSource for /proc/net/nf_conntrack.
+//
+// +stateify savable
+type netConntrackData struct {
+	kernfs.DynamicBytesFile
+
+	stack inet.Stack
+}
+
+var _ dynamicInode = (*netConntrackData)(nil)
+
+// tcpConntrackStates are the names of the TCP connection tracking states, from
+// net/netfilter/nf_conntrack_proto_tcp.c:tcp_conntrack_names.
+var tcpConntrackStates = [...]string{
+	linux.TCP_CONNTRACK_NONE:        "NONE",
+	linux.TCP_CONNTRACK_SYN_SENT:    "SYN_SENT",
+	linux.TCP_CONNTRACK_SYN_RECV:    "SYN_RECV",
+	linux.TCP_CONNTRACK_ESTABLISHED: "ESTABLISHED",
+	linux.TCP_CONNTRACK_FIN_WAIT:    "FIN_WAIT",
+	linux.TCP_CONNTRACK_CLOSE_WAIT:  "CLOSE_WAIT",
+	linux.TCP_CONNTRACK_LAST_ACK:    "LAST_ACK",
+	linux.TCP_CONNTRACK_TIME_WAIT:   "TIME_WAIT",
+	linux.TCP_CONNTRACK_CLOSE:       "CLOSE",
+	linux.TCP_CONNTRACK_SYN_SENT2:   "SYN_SENT2",
+}
+
+// writeConntrackTuple writes a connection tracking tuple as Linux's
+// net/netfilter/nf_conntrack_standalone.c:print_tuple.
+func writeConntrackTuple(buf *bytes.Buffer, family uint8, tuple inet.ConnTrackTuple) {
+	if family == linux.AF_INET {
+		fmt.Fprintf(buf, "src=%s dst=%s ", net.IP(tuple.SrcAddr), net.IP(tuple.DstAddr))
+	} else {
+		// IPv6 addresses are not compressed.
+		fmt.Fprintf(buf, "src=%s dst=%s ", uncompressedIPv6(tuple.SrcAddr), uncompressedIPv6(tuple.DstAddr))
+	}
+	fmt.Fprintf(buf, "sport=%d dport=%d ", tuple.SrcPort, tuple.DstPort)
+}
+
+// uncompressedIPv6 formats addr as Linux's %pI6.
+func uncompressedIPv6(addr []byte) string {
+	var s strings.Builder
+	for i := 0; i+1 < len(addr); i += 2 {
+		if i > 0 {
+			s.WriteByte(':')
+		}
+		fmt.Fprintf(&s, "%02x%02x", addr[i], addr[i+1])
+	}
+	return s.String()
+}
+
+// Generate implements vfs.DynamicBytesSource.Generate.
+// See Linux's net/netfilter/nf_conntrack_standalone.c:ct_seq_show.
+func (d *netConntrackData) Generate(ctx context.Context, buf *bytes.Buffer) error {
+	entries, err := d.stack.ConnTrackEntries()
+	if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		l3proto := "ipv4"
+		if e.Family == linux.AF_INET6 {
+			l3proto = "ipv6"
+		}
+		// Only TCP connections are tracked.
+		fmt.Fprintf(buf, "%-8s %d %-8s %d %d ", l3proto, e.Family, "tcp", e.Protocol, e.Timeout/time.Second)
+		if int(e.TCPState) < len(tcpConntrackStates) {
+			fmt.Fprintf(buf, "%s ", tcpConntrackStates[e.TCPState])
+		}
+		writeConntrackTuple(buf, e.Family, e.Original)
+		if e.Status&linux.IPS_SEEN_REPLY == 0 {
+			buf.WriteString("[UNREPLIED] ")
+		}
+		writeConntrackTuple(buf, e.Family, e.Reply)
+		if e.Status&linux.IPS_ASSURED != 0 {
+			buf.WriteString("[ASSURED] ")
+		}
+		buf.WriteString("mark=0 use=2\n")
+	}
+	return nil
+}
+
 // netStatData implements vfs.DynamicBytesSource for /proc/net/netstat.
 //
 // +stateify savable
@@ -65,7 +65,7 @@ var _ kernfs.Inode = (*tasksInode)(nil)
 func (fs *filesystem) newTasksInode(ctx context.Context, k *kernel.Kernel, pidns *kernel.PIDNamespace, cgroupControllers map[string]string) *tasksInode {
 	root := auth.NewRootCredentials(pidns.UserNamespace())
 	contents := map[string]kernfs.Inode{
-		"cpuinfo":     fs.newInode(ctx, root, 0444, newStaticFileSetStat(cpuInfoData(k))),
+		"cpuinfo":     fs.newInode(ctx, root, 0444, &cpuInfoData{}),
 		"filesystems": fs.newInode(ctx, root, 0444, &filesystemsData{}),
 		"loadavg":     fs.newInode(ctx, root, 0444, &loadavgData{}),
 		"sys":         fs.newSysDir(ctx, root, k),
@@ -233,33 +233,27 @@ func (i *tasksInode) DecRef(ctx context.Context) {
 	i.tasksInodeRefs.DecRef(func() { i.Destroy(ctx) })
 }
 
-// staticFileSetStat implements a special static file that allows inode
-// attributes to be set. This is to support /proc files that are readonly, but
-// allow attributes to be set.
+// cpuInfoData implements vfs.DynamicBytesSource for /proc/cpuinfo.
 //
 // +stateify savable
-type staticFileSetStat struct {
+type cpuInfoData struct {
 	dynamicBytesFileSetAttr
-	vfs.StaticData
 }
 
-var _ dynamicInode = (*staticFileSetStat)(nil)
+var _ dynamicInode = (*cpuInfoData)(nil)
 
-func newStaticFileSetStat(data string) *staticFileSetStat {
-	return &staticFileSetStat{StaticData: vfs.StaticData{Data: data}}
-}
-
-func cpuInfoData(k *kernel.Kernel) string {
+// Generate implements vfs.DynamicBytesSource.Generate.
+func (*cpuInfoData) Generate(ctx context.Context, buf *bytes.Buffer) error {
+	k := kernel.KernelFromContext(ctx)
 	features := k.FeatureSet()
 	if features == nil {
 		// Kernel is always initialized with a FeatureSet.
 		panic("cpuinfo read with nil FeatureSet")
 	}
-	var buf bytes.Buffer
-	for i, max := uint(0), k.ApplicationCores(); i < max; i++ {
-		features.WriteCPUInfoTo(i, &buf)
+	for i, max := uint(0), k.OnlineCores(); i < max; i++ {
+		features.WriteCPUInfoTo(i, buf)
 	}
-	return buf.String()
+	return nil
 }
 
 func shmData(v uint64) dynamicInode {
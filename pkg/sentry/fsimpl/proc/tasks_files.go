@@ -185,7 +185,7 @@ func (*statData) Generate(ctx context.Context, buf *bytes.Buffer) error {
 	fmt.Fprintf(buf, "cpu  %s\n", cpu)
 
 	k := kernel.KernelFromContext(ctx)
-	for c, max := uint(0), k.ApplicationCores(); c < max; c++ {
+	for c, max := uint(0), k.OnlineCores(); c < max; c++ {
 		fmt.Fprintf(buf, "cpu%d %s\n", c, cpu)
 	}
 
@@ -17,6 +17,7 @@ package proc
 import (
 	"bytes"
 	"fmt"
+	"time"
 
 	"gvisor.dev/gvisor/pkg/abi/linux"
 	"gvisor.dev/gvisor/pkg/context"
@@ -66,11 +67,16 @@ func (fs *filesystem) newSysNetDir(ctx context.Context, root *auth.Credentials,
 	if stack := k.RootNetworkNamespace().Stack(); stack != nil {
 		contents = map[string]kernfs.Inode{
 			"ipv4": fs.newStaticDir(ctx, root, map[string]kernfs.Inode{
-				"tcp_recovery": fs.newInode(ctx, root, 0644, &tcpRecoveryData{stack: stack}),
-				"tcp_rmem":     fs.newInode(ctx, root, 0644, &tcpMemData{stack: stack, dir: tcpRMem}),
-				"tcp_sack":     fs.newInode(ctx, root, 0644, &tcpSackData{stack: stack}),
-				"tcp_wmem":     fs.newInode(ctx, root, 0644, &tcpMemData{stack: stack, dir: tcpWMem}),
-				"ip_forward":   fs.newInode(ctx, root, 0444, &ipForwarding{stack: stack}),
+				"tcp_abort_on_overflow": fs.newInode(ctx, root, 0644, &tcpSynCookiesData{stack: stack, abortOnOverflow: true}),
+				"tcp_ecn":               fs.newInode(ctx, root, 0644, &tcpECNData{stack: stack}),
+				"tcp_ecn_fallback":      fs.newInode(ctx, root, 0644, &tcpECNData{stack: stack, fallback: true}),
+				"tcp_migrate_req":       fs.newInode(ctx, root, 0644, &tcpMigrateReqData{stack: stack}),
+				"tcp_recovery":          fs.newInode(ctx, root, 0644, &tcpRecoveryData{stack: stack}),
+				"tcp_rmem":              fs.newInode(ctx, root, 0644, &tcpMemData{stack: stack, dir: tcpRMem}),
+				"tcp_sack":              fs.newInode(ctx, root, 0644, &tcpSackData{stack: stack}),
+				"tcp_syncookies":        fs.newInode(ctx, root, 0644, &tcpSynCookiesData{stack: stack}),
+				"tcp_wmem":              fs.newInode(ctx, root, 0644, &tcpMemData{stack: stack, dir: tcpWMem}),
+				"ip_forward":            fs.newInode(ctx, root, 0444, &ipForwarding{stack: stack}),
 
 				// The following files are simple stubs until they are implemented in
 				// netstack, most of these files are configuration related. We use the
@@ -125,11 +131,46 @@ func (fs *filesystem) newSysNetDir(ctx context.Context, root *auth.Credentials,
 				"wmem_max":      fs.newInode(ctx, root, 0444, newStaticFile("212992")),
 			}),
 		}
+
+		// Connection tracking knobs are only available if the stack tracks
+		// connections.
+		if _, err := stack.ConnTrackSettings(); err == nil {
+			contents["netfilter"] = fs.newStaticDir(ctx, root, map[string]kernfs.Inode{
+				"nf_conntrack_buckets":                 fs.newInode(ctx, root, 0644, &conntrackData{stack: stack, setting: conntrackBuckets}),
+				"nf_conntrack_count":                   fs.newInode(ctx, root, 0444, &conntrackData{stack: stack, setting: conntrackCount}),
+				"nf_conntrack_max":                     fs.newInode(ctx, root, 0644, &conntrackData{stack: stack, setting: conntrackMax}),
+				"nf_conntrack_tcp_timeout_close":       fs.newInode(ctx, root, 0644, &conntrackData{stack: stack, setting: conntrackTCPTimeoutClose}),
+				"nf_conntrack_tcp_timeout_established": fs.newInode(ctx, root, 0644, &conntrackData{stack: stack, setting: conntrackTCPTimeoutEstablished}),
+				"nf_conntrack_tcp_timeout_syn_sent":    fs.newInode(ctx, root, 0644, &conntrackData{stack: stack, setting: conntrackTCPTimeoutSynSent}),
+				"nf_conntrack_tcp_timeout_time_wait":   fs.newInode(ctx, root, 0644, &conntrackData{stack: stack, setting: conntrackTCPTimeoutTimeWait}),
+			})
+			contents["nf_conntrack_max"] = fs.newInode(ctx, root, 0644, &conntrackData{stack: stack, setting: conntrackMax})
+		}
+
+		if stack.SupportsIPv6() {
+			contents["ipv6"] = fs.newStaticDir(ctx, root, map[string]kernfs.Inode{
+				"conf": fs.newStaticDir(ctx, root, map[string]kernfs.Inode{
+					"all":     fs.newSysNetIPv6ConfDir(ctx, root, stack),
+					"default": fs.newSysNetIPv6ConfDir(ctx, root, stack),
+				}),
+			})
+		}
 	}
 
 	return fs.newStaticDir(ctx, root, contents)
 }
 
+// newSysNetIPv6ConfDir returns the dentry corresponding to the
+// /proc/sys/net/ipv6/conf/{all,default} directories. The settings apply to all
+// interfaces.
+func (fs *filesystem) newSysNetIPv6ConfDir(ctx context.Context, root *auth.Credentials, stack inet.Stack) kernfs.Inode {
+	return fs.newStaticDir(ctx, root, map[string]kernfs.Inode{
+		"temp_prefered_lft": fs.newInode(ctx, root, 0644, &tempAddrData{stack: stack, setting: tempAddrPreferredLifetime}),
+		"temp_valid_lft":    fs.newInode(ctx, root, 0644, &tempAddrData{stack: stack, setting: tempAddrValidLifetime}),
+		"use_tempaddr":      fs.newInode(ctx, root, 0644, &tempAddrData{stack: stack, setting: tempAddrUse}),
+	})
+}
+
 // mmapMinAddrData implements vfs.DynamicBytesSource for
 // /proc/sys/vm/mmap_min_addr.
 //
@@ -271,6 +312,200 @@ func (d *tcpRecoveryData) Write(ctx context.Context, src usermem.IOSequence, off
 	return n, nil
 }
 
+// tcpECNData implements vfs.WritableDynamicBytesSource for
+// /proc/sys/net/ipv4/tcp_ecn and /proc/sys/net/ipv4/tcp_ecn_fallback.
+//
+// +stateify savable
+type tcpECNData struct {
+	kernfs.DynamicBytesFile
+
+	stack inet.Stack `state:"wait"`
+
+	// fallback is true for tcp_ecn_fallback.
+	fallback bool
+}
+
+var _ vfs.WritableDynamicBytesSource = (*tcpECNData)(nil)
+
+// Generate implements vfs.DynamicBytesSource.Generate.
+func (d *tcpECNData) Generate(ctx context.Context, buf *bytes.Buffer) error {
+	var v int32
+	if d.fallback {
+		fallback, err := d.stack.TCPECNFallback()
+		if err != nil {
+			return err
+		}
+		if fallback {
+			v = 1
+		}
+	} else {
+		mode, err := d.stack.TCPECN()
+		if err != nil {
+			return err
+		}
+		v = int32(mode)
+	}
+
+	_, err := buf.WriteString(fmt.Sprintf("%d\n", v))
+	return err
+}
+
+// Write implements vfs.WritableDynamicBytesSource.Write.
+func (d *tcpECNData) Write(ctx context.Context, src usermem.IOSequence, offset int64) (int64, error) {
+	if offset != 0 {
+		// No need to handle partial writes thus far.
+		return 0, syserror.EINVAL
+	}
+	if src.NumBytes() == 0 {
+		return 0, nil
+	}
+
+	// Limit the amount of memory allocated.
+	src = src.TakeFirst(usermem.PageSize - 1)
+
+	var v int32
+	n, err := usermem.CopyInt32StringInVec(ctx, src.IO, src.Addrs, &v, src.Opts)
+	if err != nil {
+		return 0, err
+	}
+	if d.fallback {
+		if v < 0 || v > 1 {
+			return 0, syserror.EINVAL
+		}
+		err = d.stack.SetTCPECNFallback(v != 0)
+	} else {
+		err = d.stack.SetTCPECN(inet.TCPECNMode(v))
+	}
+	if err != nil {
+		return 0, err
+	}
+	return n, nil
+}
+
+// tcpSynCookiesData implements vfs.WritableDynamicBytesSource for
+// /proc/sys/net/ipv4/tcp_syncookies and
+// /proc/sys/net/ipv4/tcp_abort_on_overflow.
+//
+// +stateify savable
+type tcpSynCookiesData struct {
+	kernfs.DynamicBytesFile
+
+	stack inet.Stack `state:"wait"`
+
+	// abortOnOverflow is true for tcp_abort_on_overflow.
+	abortOnOverflow bool
+}
+
+var _ vfs.WritableDynamicBytesSource = (*tcpSynCookiesData)(nil)
+
+// Generate implements vfs.DynamicBytesSource.Generate.
+func (d *tcpSynCookiesData) Generate(ctx context.Context, buf *bytes.Buffer) error {
+	var v int32
+	if d.abortOnOverflow {
+		abort, err := d.stack.TCPAbortOnOverflow()
+		if err != nil {
+			return err
+		}
+		if abort {
+			v = 1
+		}
+	} else {
+		mode, err := d.stack.TCPSynCookies()
+		if err != nil {
+			return err
+		}
+		v = int32(mode)
+	}
+
+	_, err := buf.WriteString(fmt.Sprintf("%d\n", v))
+	return err
+}
+
+// Write implements vfs.WritableDynamicBytesSource.Write.
+func (d *tcpSynCookiesData) Write(ctx context.Context, src usermem.IOSequence, offset int64) (int64, error) {
+	if offset != 0 {
+		// No need to handle partial writes thus far.
+		return 0, syserror.EINVAL
+	}
+	if src.NumBytes() == 0 {
+		return 0, nil
+	}
+
+	// Limit the amount of memory allocated.
+	src = src.TakeFirst(usermem.PageSize - 1)
+
+	var v int32
+	n, err := usermem.CopyInt32StringInVec(ctx, src.IO, src.Addrs, &v, src.Opts)
+	if err != nil {
+		return 0, err
+	}
+	if d.abortOnOverflow {
+		if v < 0 || v > 1 {
+			return 0, syserror.EINVAL
+		}
+		err = d.stack.SetTCPAbortOnOverflow(v != 0)
+	} else {
+		err = d.stack.SetTCPSynCookies(inet.TCPSynCookiesMode(v))
+	}
+	if err != nil {
+		return 0, err
+	}
+	return n, nil
+}
+
+// tcpMigrateReqData implements vfs.WritableDynamicBytesSource for
+// /proc/sys/net/ipv4/tcp_migrate_req.
+//
+// +stateify savable
+type tcpMigrateReqData struct {
+	kernfs.DynamicBytesFile
+
+	stack inet.Stack `state:"wait"`
+}
+
+var _ vfs.WritableDynamicBytesSource = (*tcpMigrateReqData)(nil)
+
+// Generate implements vfs.DynamicBytesSource.Generate.
+func (d *tcpMigrateReqData) Generate(ctx context.Context, buf *bytes.Buffer) error {
+	migrate, err := d.stack.TCPMigrateReq()
+	if err != nil {
+		return err
+	}
+	val := "0\n"
+	if migrate {
+		val = "1\n"
+	}
+	_, err = buf.WriteString(val)
+	return err
+}
+
+// Write implements vfs.WritableDynamicBytesSource.Write.
+func (d *tcpMigrateReqData) Write(ctx context.Context, src usermem.IOSequence, offset int64) (int64, error) {
+	if offset != 0 {
+		// No need to handle partial writes thus far.
+		return 0, syserror.EINVAL
+	}
+	if src.NumBytes() == 0 {
+		return 0, nil
+	}
+
+	// Limit the amount of memory allocated.
+	src = src.TakeFirst(usermem.PageSize - 1)
+
+	var v int32
+	n, err := usermem.CopyInt32StringInVec(ctx, src.IO, src.Addrs, &v, src.Opts)
+	if err != nil {
+		return 0, err
+	}
+	if v < 0 || v > 1 {
+		return 0, syserror.EINVAL
+	}
+	if err := d.stack.SetTCPMigrateReq(v != 0); err != nil {
+		return 0, err
+	}
+	return n, nil
+}
+
 // tcpMemData implements vfs.WritableDynamicBytesSource for
 // /proc/sys/net/ipv4/tcp_rmem and /proc/sys/net/ipv4/tcp_wmem.
 //
@@ -418,3 +653,208 @@ func (ipf *ipForwarding) Write(ctx context.Context, src usermem.IOSequence, offs
 	}
 	return n, nil
 }
+
+// conntrackSetting identifies one of the connection tracking sysctls.
+type conntrackSetting int
+
+const (
+	conntrackMax conntrackSetting = iota
+	conntrackCount
+	conntrackBuckets
+	conntrackTCPTimeoutSynSent
+	conntrackTCPTimeoutEstablished
+	conntrackTCPTimeoutTimeWait
+	conntrackTCPTimeoutClose
+)
+
+// conntrackData implements vfs.WritableDynamicBytesSource for the files in
+// /proc/sys/net/netfilter and for /proc/sys/net/nf_conntrack_max.
+//
+// +stateify savable
+type conntrackData struct {
+	kernfs.DynamicBytesFile
+
+	stack   inet.Stack `state:"wait"`
+	setting conntrackSetting
+}
+
+var _ vfs.WritableDynamicBytesSource = (*conntrackData)(nil)
+
+// Generate implements vfs.DynamicBytesSource.Generate.
+func (d *conntrackData) Generate(ctx context.Context, buf *bytes.Buffer) error {
+	if d.setting == conntrackCount {
+		stats, err := d.stack.ConnTrackStats()
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(buf, "%d\n", stats.Entries)
+		return nil
+	}
+
+	s, err := d.stack.ConnTrackSettings()
+	if err != nil {
+		return err
+	}
+	var v uint64
+	switch d.setting {
+	case conntrackMax:
+		v = uint64(s.Max)
+	case conntrackBuckets:
+		v = uint64(s.Buckets)
+	case conntrackTCPTimeoutSynSent:
+		v = uint64(s.TCPTimeoutSynSent / time.Second)
+	case conntrackTCPTimeoutEstablished:
+		v = uint64(s.TCPTimeoutEstablished / time.Second)
+	case conntrackTCPTimeoutTimeWait:
+		v = uint64(s.TCPTimeoutTimeWait / time.Second)
+	case conntrackTCPTimeoutClose:
+		v = uint64(s.TCPTimeoutClose / time.Second)
+	}
+	fmt.Fprintf(buf, "%d\n", v)
+	return nil
+}
+
+// Write implements vfs.WritableDynamicBytesSource.Write.
+func (d *conntrackData) Write(ctx context.Context, src usermem.IOSequence, offset int64) (int64, error) {
+	if offset != 0 {
+		// No need to handle partial writes thus far.
+		return 0, syserror.EINVAL
+	}
+	if d.setting == conntrackCount {
+		// Like Linux, the connection count can't be written even by root.
+		return 0, syserror.EACCES
+	}
+	if src.NumBytes() == 0 {
+		return 0, nil
+	}
+
+	// Limit input size so as not to impact performance if input size is large.
+	src = src.TakeFirst(usermem.PageSize - 1)
+
+	var v int32
+	n, err := usermem.CopyInt32StringInVec(ctx, src.IO, src.Addrs, &v, src.Opts)
+	if err != nil {
+		return 0, err
+	}
+	if v < 0 {
+		return 0, syserror.EINVAL
+	}
+
+	s, err := d.stack.ConnTrackSettings()
+	if err != nil {
+		return 0, err
+	}
+	switch d.setting {
+	case conntrackMax:
+		s.Max = uint32(v)
+	case conntrackBuckets:
+		if v == 0 {
+			return 0, syserror.EINVAL
+		}
+		s.Buckets = uint32(v)
+	case conntrackTCPTimeoutSynSent:
+		s.TCPTimeoutSynSent = time.Duration(v) * time.Second
+	case conntrackTCPTimeoutEstablished:
+		s.TCPTimeoutEstablished = time.Duration(v) * time.Second
+	case conntrackTCPTimeoutTimeWait:
+		s.TCPTimeoutTimeWait = time.Duration(v) * time.Second
+	case conntrackTCPTimeoutClose:
+		s.TCPTimeoutClose = time.Duration(v) * time.Second
+	}
+	if err := d.stack.SetConnTrackSettings(s); err != nil {
+		return 0, err
+	}
+	return n, nil
+}
+
+// tempAddrSetting identifies one of the IPv6 temporary address sysctls.
+type tempAddrSetting int
+
+const (
+	tempAddrUse tempAddrSetting = iota
+	tempAddrValidLifetime
+	tempAddrPreferredLifetime
+)
+
+// tempAddrData implements vfs.WritableDynamicBytesSource for the IPv6
+// temporary address files in /proc/sys/net/ipv6/conf/{all,default}.
+//
+// +stateify savable
+type tempAddrData struct {
+	kernfs.DynamicBytesFile
+
+	stack   inet.Stack `state:"wait"`
+	setting tempAddrSetting
+}
+
+var _ vfs.WritableDynamicBytesSource = (*tempAddrData)(nil)
+
+// Generate implements vfs.DynamicBytesSource.Generate.
+func (d *tempAddrData) Generate(ctx context.Context, buf *bytes.Buffer) error {
+	s, err := d.stack.IPv6TempAddrSettings()
+	if err != nil {
+		return err
+	}
+	var v int64
+	switch d.setting {
+	case tempAddrUse:
+		v = int64(s.UseTempAddr)
+	case tempAddrValidLifetime:
+		v = int64(s.ValidLifetime / time.Second)
+	case tempAddrPreferredLifetime:
+		v = int64(s.PreferredLifetime / time.Second)
+	}
+	fmt.Fprintf(buf, "%d\n", v)
+	return nil
+}
+
+// Write implements vfs.WritableDynamicBytesSource.Write.
+func (d *tempAddrData) Write(ctx context.Context, src usermem.IOSequence, offset int64) (int64, error) {
+	if offset != 0 {
+		// No need to handle partial writes thus far.
+		return 0, syserror.EINVAL
+	}
+	if src.NumBytes() == 0 {
+		return 0, nil
+	}
+
+	// Limit input size so as not to impact performance if input size is large.
+	src = src.TakeFirst(usermem.PageSize - 1)
+
+	var v int32
+	n, err := usermem.CopyInt32StringInVec(ctx, src.IO, src.Addrs, &v, src.Opts)
+	if err != nil {
+		return 0, err
+	}
+
+	s, err := d.stack.IPv6TempAddrSettings()
+	if err != nil {
+		return 0, err
+	}
+	switch d.setting {
+	case tempAddrUse:
+		// As in Linux, values above 1 also prefer temporary addresses and
+		// negative values disable them.
+		switch {
+		case v <= 0:
+			s.UseTempAddr = 0
+		case v == 1:
+			s.UseTempAddr = 1
+		default:
+			s.UseTempAddr = 2
+		}
+	case tempAddrValidLifetime, tempAddrPreferredLifetime:
+		if v < 0 {
+			return 0, syserror.EINVAL
+		}
+		if d.setting == tempAddrValidLifetime {
+			s.ValidLifetime = time.Duration(v) * time.Second
+		} else {
+			s.PreferredLifetime = time.Duration(v) * time.Second
+		}
+	}
+	if err := d.stack.SetIPv6TempAddrSettings(s); err != nil {
+		return 0, err
+	}
+	return n, nil
+}
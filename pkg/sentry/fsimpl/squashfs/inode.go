@@ -0,0 +1,226 @@
+// Copyright 2021 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package squashfs
+
+import (
+	"fmt"
+	"sync/atomic"
+
+	"gvisor.dev/gvisor/pkg/abi/linux"
+	"gvisor.dev/gvisor/pkg/context"
+	"gvisor.dev/gvisor/pkg/sentry/kernel/auth"
+	"gvisor.dev/gvisor/pkg/sentry/vfs"
+	"gvisor.dev/gvisor/pkg/squashfs"
+	"gvisor.dev/gvisor/pkg/syserror"
+)
+
+// inode represents an SquashFS inode.
+//
+// inode uses the same inheritance pattern that pkg/sentry/vfs structures use.
+// This has been done to increase memory locality.
+//
+// Implementations:
+//    inode --
+//           |-- directory
+//           |-- regularFile
+//           |-- symlink
+//           |-- specialFile
+//
+// +stateify savable
+type inode struct {
+	// refs is a reference count. refs is accessed using atomic memory operations.
+	refs int64
+
+	// fs is the containing filesystem.
+	fs *filesystem
+
+	// diskInode is the inode in the image. Immutable.
+	diskInode *squashfs.Inode `state:"nosave"`
+
+	locks vfs.FileLocks
+
+	// This is immutable. The first field of the implementations must have inode
+	// as the first field to ensure temporality.
+	impl interface{}
+}
+
+// incRef increments the inode ref count.
+func (in *inode) incRef() {
+	atomic.AddInt64(&in.refs, 1)
+}
+
+// tryIncRef tries to increment the ref count. Returns true if successful.
+func (in *inode) tryIncRef() bool {
+	for {
+		refs := atomic.LoadInt64(&in.refs)
+		if refs == 0 {
+			return false
+		}
+		if atomic.CompareAndSwapInt64(&in.refs, refs, refs+1) {
+			return true
+		}
+	}
+}
+
+// decRef decrements the inode ref count and releases the inode resources if
+// the ref count hits 0.
+//
+// Precondition: Must have locked filesystem.mu.
+func (in *inode) decRef() {
+	if refs := atomic.AddInt64(&in.refs, -1); refs == 0 {
+		delete(in.fs.inodeCache, in.diskInode.Ref)
+		if f, ok := in.impl.(*regularFile); ok {
+			f.dropCache()
+		}
+	} else if refs < 0 {
+		panic("squashfs.inode.decRef() called without holding a reference")
+	}
+}
+
+// newInode is the inode constructor. Reads the inode from the image.
+func newInode(fs *filesystem, ref uint64) (*inode, error) {
+	diskInode, err := fs.image.Inode(ref)
+	if err != nil {
+		return nil, err
+	}
+
+	switch linux.FileMode(diskInode.Mode).FileType() {
+	case linux.ModeSymlink:
+		f, err := newSymlink(fs, diskInode)
+		if err != nil {
+			return nil, err
+		}
+		return &f.inode, nil
+	case linux.ModeRegular:
+		f := newRegularFile(fs, diskInode)
+		return &f.inode, nil
+	case linux.ModeDirectory:
+		f, err := newDirectory(fs, diskInode)
+		if err != nil {
+			return nil, err
+		}
+		return &f.inode, nil
+	default:
+		f := &specialFile{}
+		f.inode.init(fs, diskInode, f)
+		return &f.inode, nil
+	}
+}
+
+func (in *inode) init(fs *filesystem, diskInode *squashfs.Inode, impl interface{}) {
+	in.fs = fs
+	in.diskInode = diskInode
+	in.impl = impl
+}
+
+// specialFile represents a device, named pipe or socket inode. Such inodes
+// have no data in the image.
+//
+// +stateify savable
+type specialFile struct {
+	inode inode
+}
+
+// open creates and returns a file description for the dentry passed in.
+func (in *inode) open(ctx context.Context, rp *vfs.ResolvingPath, vfsd *vfs.Dentry, opts *vfs.OpenOptions) (*vfs.FileDescription, error) {
+	ats := vfs.AccessTypesForOpenFlags(opts)
+	if err := in.checkPermissions(rp.Credentials(), ats); err != nil {
+		return nil, err
+	}
+	mnt := rp.Mount()
+	switch in.impl.(type) {
+	case *regularFile:
+		var fd regularFileFD
+		fd.LockFD.Init(&in.locks)
+		if err := fd.vfsfd.Init(&fd, opts.Flags, mnt, vfsd, &vfs.FileDescriptionOptions{}); err != nil {
+			return nil, err
+		}
+		return &fd.vfsfd, nil
+	case *directory:
+		// Can't open directories writably.
+		if ats&vfs.MayWrite != 0 {
+			return nil, syserror.EISDIR
+		}
+		var fd directoryFD
+		fd.LockFD.Init(&in.locks)
+		if err := fd.vfsfd.Init(&fd, opts.Flags, mnt, vfsd, &vfs.FileDescriptionOptions{}); err != nil {
+			return nil, err
+		}
+		return &fd.vfsfd, nil
+	case *symlink:
+		if opts.Flags&linux.O_PATH == 0 {
+			// Can't open symlinks without O_PATH.
+			return nil, syserror.ELOOP
+		}
+		var fd symlinkFD
+		fd.LockFD.Init(&in.locks)
+		if err := fd.vfsfd.Init(&fd, opts.Flags, mnt, vfsd, &vfs.FileDescriptionOptions{}); err != nil {
+			return nil, err
+		}
+		return &fd.vfsfd, nil
+	case *specialFile:
+		switch linux.FileMode(in.diskInode.Mode).FileType() {
+		case linux.ModeCharacterDevice:
+			major, minor := linux.DecodeDeviceID(in.diskInode.Rdev)
+			return rp.VirtualFilesystem().OpenDeviceSpecialFile(ctx, mnt, vfsd, vfs.CharDevice, uint32(major), minor, opts)
+		case linux.ModeBlockDevice:
+			major, minor := linux.DecodeDeviceID(in.diskInode.Rdev)
+			return rp.VirtualFilesystem().OpenDeviceSpecialFile(ctx, mnt, vfsd, vfs.BlockDevice, uint32(major), minor, opts)
+		default:
+			// Named pipes and sockets in the image can't be opened.
+			return nil, syserror.ENXIO
+		}
+	default:
+		panic(fmt.Sprintf("unknown inode type: %T", in.impl))
+	}
+}
+
+func (in *inode) mode() linux.FileMode {
+	return linux.FileMode(in.diskInode.Mode)
+}
+
+func (in *inode) checkPermissions(creds *auth.Credentials, ats vfs.AccessTypes) error {
+	return vfs.GenericCheckPermissions(creds, ats, in.mode(), auth.KUID(in.diskInode.UID), auth.KGID(in.diskInode.GID))
+}
+
+// statTo writes the statx fields to the output parameter.
+func (in *inode) statTo(stat *linux.Statx) {
+	stat.Mask = linux.STATX_TYPE | linux.STATX_MODE | linux.STATX_NLINK |
+		linux.STATX_UID | linux.STATX_GID | linux.STATX_INO | linux.STATX_SIZE |
+		linux.STATX_BLOCKS | linux.STATX_ATIME | linux.STATX_CTIME | linux.STATX_MTIME
+	blkSize := in.fs.image.BlockSize()
+	stat.Blksize = blkSize
+	stat.Mode = uint16(in.diskInode.Mode)
+	stat.Nlink = in.diskInode.Nlink
+	stat.UID = in.diskInode.UID
+	stat.GID = in.diskInode.GID
+	stat.Ino = uint64(in.diskInode.Ino)
+	stat.Size = in.diskInode.Size
+	stat.Blocks = (in.diskInode.Size + uint64(blkSize) - 1) / uint64(blkSize) * uint64(blkSize) / 512
+	// The image only records the modification time.
+	mtime := linux.StatxTimestamp{
+		Sec: int64(in.diskInode.Mtime),
+	}
+	stat.Atime = mtime
+	stat.Ctime = mtime
+	stat.Mtime = mtime
+	if ft := in.mode().FileType(); ft == linux.ModeCharacterDevice || ft == linux.ModeBlockDevice {
+		major, minor := linux.DecodeDeviceID(in.diskInode.Rdev)
+		stat.RdevMajor = uint32(major)
+		stat.RdevMinor = minor
+	}
+	stat.DevMajor = linux.UNNAMED_MAJOR
+	stat.DevMinor = in.fs.devMinor
+}
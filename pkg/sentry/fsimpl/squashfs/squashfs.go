@@ -0,0 +1,143 @@
+// Copyright 2021 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package squashfs implements readonly SquashFS filesystems, read directly
+// from an image in a host file without going through a gofer. This allows
+// single-file application images to be mounted without extracting them.
+//
+// Lock order:
+//
+// filesystem.mu
+//   regularFile.mapsMu
+//     regularFile.dataMu
+package squashfs
+
+import (
+	"io"
+	"strconv"
+
+	"gvisor.dev/gvisor/pkg/context"
+	"gvisor.dev/gvisor/pkg/fd"
+	"gvisor.dev/gvisor/pkg/log"
+	"gvisor.dev/gvisor/pkg/sentry/kernel/auth"
+	"gvisor.dev/gvisor/pkg/sentry/pgalloc"
+	"gvisor.dev/gvisor/pkg/sentry/vfs"
+	"gvisor.dev/gvisor/pkg/squashfs"
+	"gvisor.dev/gvisor/pkg/syserror"
+)
+
+// Name is the name of this filesystem.
+const Name = "squashfs"
+
+// FilesystemType implements vfs.FilesystemType.
+//
+// +stateify savable
+type FilesystemType struct{}
+
+// Name implements vfs.FilesystemType.Name.
+func (FilesystemType) Name() string {
+	return Name
+}
+
+// Release implements vfs.FilesystemType.Release.
+func (FilesystemType) Release(ctx context.Context) {}
+
+// GetFilesystem implements vfs.FilesystemType.GetFilesystem.
+//
+// The image is read from the block device at source, unless the mount data
+// specifies the host FD of the image as "fd=N". The host FD is not owned by
+// the filesystem.
+func (fsType FilesystemType) GetFilesystem(ctx context.Context, vfsObj *vfs.VirtualFilesystem, creds *auth.Credentials, source string, opts vfs.GetFilesystemOptions) (*vfs.Filesystem, *vfs.Dentry, error) {
+	mfp := pgalloc.MemoryFileProviderFromContext(ctx)
+	if mfp == nil {
+		ctx.Warningf("squashfs.FilesystemType.GetFilesystem: context does not provide a pgalloc.MemoryFileProvider")
+		return nil, nil, syserror.EINVAL
+	}
+
+	mopts := vfs.GenericParseMountOptions(opts.Data)
+	var (
+		src  io.ReaderAt
+		disk vfs.Disk
+	)
+	if hostFDStr, ok := mopts["fd"]; ok {
+		delete(mopts, "fd")
+		hostFD, err := strconv.Atoi(hostFDStr)
+		if err != nil || hostFD < 0 {
+			log.Warningf("%s.GetFilesystem: invalid host FD: fd=%s", fsType.Name(), hostFDStr)
+			return nil, nil, syserror.EINVAL
+		}
+		// The fd.ReadWriter returned from fd.NewReadWriter() does not take
+		// ownership of the file descriptor and hence will not close it when it
+		// is garbage collected.
+		src = fd.NewReadWriter(hostFD)
+	}
+	if len(mopts) != 0 {
+		log.Warningf("%s.GetFilesystem: unsupported or unknown options: %v", fsType.Name(), mopts)
+		return nil, nil, syserror.EINVAL
+	}
+	if src == nil {
+		var err error
+		if disk, err = vfsObj.GetDiskFromSource(ctx, creds, source); err != nil {
+			return nil, nil, err
+		}
+		// The image is read outside of the tasks' syscalls, so its I/O
+		// isn't done on behalf of any task.
+		src = vfs.DiskIO{Ctx: context.Background(), Disk: disk}
+	}
+
+	image, err := squashfs.OpenImage(src)
+	if err != nil {
+		if disk != nil {
+			disk.DecRef(ctx)
+		}
+		// mount(2) specifies that EINVAL should be returned if the superblock is
+		// invalid.
+		log.Warningf("%s.GetFilesystem: failed to read image: %v", fsType.Name(), err)
+		return nil, nil, syserror.EINVAL
+	}
+
+	devMinor, err := vfsObj.GetAnonBlockDevMinor()
+	if err != nil {
+		if disk != nil {
+			disk.DecRef(ctx)
+		}
+		return nil, nil, err
+	}
+
+	fs := &filesystem{
+		image:      image,
+		disk:       disk,
+		mfp:        mfp,
+		inodeCache: make(map[uint64]*inode),
+		devMinor:   devMinor,
+	}
+	fs.vfsfs.Init(vfsObj, &fsType, fs)
+
+	fs.mu.Lock()
+	rootInode, err := fs.getOrCreateInodeLocked(image.RootInodeRef())
+	if err != nil {
+		fs.mu.Unlock()
+		fs.vfsfs.DecRef(ctx)
+		return nil, nil, err
+	}
+	if !rootInode.isDir() {
+		fs.mu.Unlock()
+		fs.vfsfs.DecRef(ctx)
+		return nil, nil, syserror.EINVAL
+	}
+	rootInode.incRef()
+	fs.mu.Unlock()
+
+	return &fs.vfsfs, &newDentry(rootInode).vfsd, nil
+}
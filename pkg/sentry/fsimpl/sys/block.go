@@ -0,0 +1,130 @@
+// Copyright 2021 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sys
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+
+	"gvisor.dev/gvisor/pkg/abi/linux"
+	"gvisor.dev/gvisor/pkg/context"
+	"gvisor.dev/gvisor/pkg/sentry/devices/blockdev"
+	"gvisor.dev/gvisor/pkg/sentry/fsimpl/kernfs"
+	"gvisor.dev/gvisor/pkg/sentry/kernel/auth"
+	"gvisor.dev/gvisor/pkg/sentry/vfs"
+	"gvisor.dev/gvisor/pkg/syserror"
+)
+
+// blockDirs returns the directories of the block devices registered with a
+// device special file, as shown in /sys/devices/virtual/block, and the
+// symlinks to them shown in /sys/block, keyed by device name, and in
+// /sys/dev/block, keyed by device number.
+//
+// Devices are listed when the filesystem is mounted, but the attributes of
+// their backing disks are read when the attributes are.
+func blockDirs(ctx context.Context, fs *filesystem, creds *auth.Credentials) (devices, block, dev map[string]kernfs.Inode) {
+	devices = make(map[string]kernfs.Inode)
+	block = make(map[string]kernfs.Inode)
+	dev = make(map[string]kernfs.Inode)
+	static := func(data string) kernfs.Inode {
+		return fs.newStaticFile(ctx, creds, linux.FileMode(0444), data)
+	}
+	sectorSize := fmt.Sprintf("%d\n", blockdev.SectorSize)
+	fs.VFSFilesystem().VirtualFilesystem().ForEachDevice(func(kind vfs.DeviceKind, major, minor uint32, opts *vfs.RegisterDeviceOptions) error {
+		if kind != vfs.BlockDevice || opts.Pathname == "" {
+			return nil
+		}
+		// As in Linux, slashes in device names are replaced by '!'.
+		name := strings.Replace(opts.Pathname, "/", "!", -1)
+		devices[name] = fs.newDir(ctx, creds, defaultSysDirMode, map[string]kernfs.Inode{
+			"dev": static(fmt.Sprintf("%d:%d\n", major, minor)),
+			"queue": fs.newDir(ctx, creds, defaultSysDirMode, map[string]kernfs.Inode{
+				"hw_sector_size":      static(sectorSize),
+				"logical_block_size":  static(sectorSize),
+				"physical_block_size": static(sectorSize),
+				"rotational":          static("0\n"),
+			}),
+			"removable": static("0\n"),
+			"ro":        fs.newBlockFile(ctx, creds, major, minor, "ro"),
+			"size":      fs.newBlockFile(ctx, creds, major, minor, "size"),
+		})
+		block[name] = fs.newSymlink(ctx, creds, "../devices/virtual/block/"+name)
+		dev[fmt.Sprintf("%d:%d", major, minor)] = fs.newSymlink(ctx, creds, "../../devices/virtual/block/"+name)
+		return nil
+	})
+	return devices, block, dev
+}
+
+// blockFile implements kernfs.Inode for the attributes of block devices that
+// are read from their backing disks.
+//
+// +stateify savable
+type blockFile struct {
+	implStatFS
+	kernfs.DynamicBytesFile
+
+	vfsObj *vfs.VirtualFilesystem
+	major  uint32
+	minor  uint32
+
+	// attr is the name of the attribute.
+	attr string
+}
+
+// Generate implements vfs.DynamicBytesSource.Generate.
+func (f *blockFile) Generate(ctx context.Context, buf *bytes.Buffer) error {
+	var (
+		size     int64
+		readOnly bool
+	)
+	disk, err := f.vfsObj.GetDisk(ctx, f.major, f.minor)
+	switch err {
+	case nil:
+		size = disk.Size()
+		readOnly = disk.ReadOnly()
+		disk.DecRef(ctx)
+	case syserror.ENXIO:
+		// Devices without a backing disk, such as unbound loop devices, are
+		// shown as empty.
+	default:
+		return err
+	}
+	switch f.attr {
+	case "ro":
+		if readOnly {
+			buf.WriteString("1\n")
+		} else {
+			buf.WriteString("0\n")
+		}
+	case "size":
+		// The size is in 512-byte sectors regardless of the block size.
+		fmt.Fprintf(buf, "%d\n", size/512)
+	default:
+		panic(fmt.Sprintf("unknown block device attribute %q", f.attr))
+	}
+	return nil
+}
+
+func (fs *filesystem) newBlockFile(ctx context.Context, creds *auth.Credentials, major, minor uint32, attr string) kernfs.Inode {
+	f := &blockFile{
+		vfsObj: fs.VFSFilesystem().VirtualFilesystem(),
+		major:  major,
+		minor:  minor,
+		attr:   attr,
+	}
+	f.DynamicBytesFile.Init(ctx, creds, linux.UNNAMED_MAJOR, fs.devMinor, fs.NextIno(), f, linux.FileMode(0444))
+	return f
+}
@@ -0,0 +1,204 @@
+// Copyright 2021 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sys
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+
+	"gvisor.dev/gvisor/pkg/abi/linux"
+	"gvisor.dev/gvisor/pkg/context"
+	"gvisor.dev/gvisor/pkg/sentry/fsimpl/kernfs"
+	"gvisor.dev/gvisor/pkg/sentry/kernel"
+	"gvisor.dev/gvisor/pkg/sentry/kernel/auth"
+)
+
+// cpuCache describes a CPU cache shown in
+// /sys/devices/system/cpu/cpuN/cache/indexM.
+type cpuCache struct {
+	level uint32
+
+	// typ is the type of the cache: "Data", "Instruction" or "Unified".
+	typ string
+
+	lineSize   uint32
+	ways       uint32
+	sets       uint32
+	partitions uint32
+
+	// shared is true if the cache is shared by all CPUs, rather than private
+	// to each CPU.
+	shared bool
+}
+
+// cpuDir returns /sys/devices/system/cpu.
+//
+// The topology shown is consistent with the number of CPUs available to the
+// sandbox: each CPU is a core of its own in a single package, with the caches
+// described by the kernel's CPUID feature set.
+func cpuDir(ctx context.Context, fs *filesystem, creds *auth.Credentials) kernfs.Inode {
+	k := kernel.KernelFromContext(ctx)
+	maxCPUCores := k.ApplicationCores()
+	caches := cpuCaches(k.FeatureSet())
+	children := map[string]kernfs.Inode{
+		"kernel_max": fs.newStaticFile(ctx, creds, linux.FileMode(0444), fmt.Sprintf("%d\n", maxCPUCores-1)),
+		"offline":    fs.newCPUStateFile(ctx, creds, cpuStateOffline),
+		"online":     fs.newCPUStateFile(ctx, creds, cpuStateOnline),
+		"possible":   fs.newCPUFile(ctx, creds, maxCPUCores, linux.FileMode(0444)),
+		"present":    fs.newCPUFile(ctx, creds, maxCPUCores, linux.FileMode(0444)),
+	}
+	for i := uint(0); i < maxCPUCores; i++ {
+		children[fmt.Sprintf("cpu%d", i)] = cpuNDir(ctx, fs, creds, i, maxCPUCores, caches)
+	}
+	return fs.newDir(ctx, creds, defaultSysDirMode, children)
+}
+
+// cpuNDir returns /sys/devices/system/cpu/cpuN for the CPU with the given
+// number.
+func cpuNDir(ctx context.Context, fs *filesystem, creds *auth.Credentials, cpu, maxCPUCores uint, caches []cpuCache) kernfs.Inode {
+	static := func(data string) kernfs.Inode {
+		return fs.newStaticFile(ctx, creds, linux.FileMode(0444), data)
+	}
+	allList := cpuList(0, maxCPUCores-1)
+	allMap := cpuMap(maxCPUCores, 0, maxCPUCores-1)
+	thisList := cpuList(cpu, cpu)
+	thisMap := cpuMap(maxCPUCores, cpu, cpu)
+
+	cacheChildren := make(map[string]kernfs.Inode, len(caches))
+	for i, c := range caches {
+		sharedList, sharedMap := thisList, thisMap
+		if c.shared {
+			sharedList, sharedMap = allList, allMap
+		}
+		size := c.lineSize * c.ways * c.sets * c.partitions
+		cacheChildren[fmt.Sprintf("index%d", i)] = fs.newDir(ctx, creds, defaultSysDirMode, map[string]kernfs.Inode{
+			"coherency_line_size":     static(fmt.Sprintf("%d\n", c.lineSize)),
+			"level":                   static(fmt.Sprintf("%d\n", c.level)),
+			"number_of_sets":          static(fmt.Sprintf("%d\n", c.sets)),
+			"physical_line_partition": static(fmt.Sprintf("%d\n", c.partitions)),
+			"shared_cpu_list":         static(sharedList + "\n"),
+			"shared_cpu_map":          static(sharedMap + "\n"),
+			"size":                    static(fmt.Sprintf("%dK\n", size/1024)),
+			"type":                    static(c.typ + "\n"),
+			"ways_of_associativity":   static(fmt.Sprintf("%d\n", c.ways)),
+		})
+	}
+
+	children := map[string]kernfs.Inode{
+		"cache": fs.newDir(ctx, creds, defaultSysDirMode, cacheChildren),
+		"topology": fs.newDir(ctx, creds, defaultSysDirMode, map[string]kernfs.Inode{
+			"core_cpus":            static(thisMap + "\n"),
+			"core_cpus_list":       static(thisList + "\n"),
+			"core_id":              static(fmt.Sprintf("%d\n", cpu)),
+			"core_siblings":        static(allMap + "\n"),
+			"core_siblings_list":   static(allList + "\n"),
+			"die_id":               static("0\n"),
+			"package_cpus":         static(allMap + "\n"),
+			"package_cpus_list":    static(allList + "\n"),
+			"physical_package_id":  static("0\n"),
+			"thread_siblings":      static(thisMap + "\n"),
+			"thread_siblings_list": static(thisList + "\n"),
+		}),
+	}
+	// As in Linux, CPU 0 can't be taken offline, so it has no online file.
+	if cpu != 0 {
+		children["online"] = fs.newCPUStateFile(ctx, creds, int(cpu))
+	}
+	return fs.newDir(ctx, creds, defaultSysDirMode, children)
+}
+
+// Special values of cpuStateFile.cpu.
+const (
+	// cpuStateOnline shows the list of online CPUs.
+	cpuStateOnline = -1
+
+	// cpuStateOffline shows the list of offline CPUs.
+	cpuStateOffline = -2
+)
+
+// cpuStateFile implements kernfs.Inode for files that show which CPUs are
+// online: /sys/devices/system/cpu/{online,offline} and
+// /sys/devices/system/cpu/cpuN/online.
+//
+// +stateify savable
+type cpuStateFile struct {
+	implStatFS
+	kernfs.DynamicBytesFile
+
+	// cpu is the CPU whose state is shown, or cpuStateOnline or
+	// cpuStateOffline.
+	cpu int
+}
+
+// Generate implements vfs.DynamicBytesSource.Generate.
+func (c *cpuStateFile) Generate(ctx context.Context, buf *bytes.Buffer) error {
+	k := kernel.KernelFromContext(ctx)
+	online, possible := k.OnlineCores(), k.ApplicationCores()
+	switch c.cpu {
+	case cpuStateOnline:
+		fmt.Fprintf(buf, "%s\n", cpuList(0, online-1))
+	case cpuStateOffline:
+		if online < possible {
+			buf.WriteString(cpuList(online, possible-1))
+		}
+		buf.WriteString("\n")
+	default:
+		if uint(c.cpu) < online {
+			buf.WriteString("1\n")
+		} else {
+			buf.WriteString("0\n")
+		}
+	}
+	return nil
+}
+
+func (fs *filesystem) newCPUStateFile(ctx context.Context, creds *auth.Credentials, cpu int) kernfs.Inode {
+	c := &cpuStateFile{cpu: cpu}
+	c.DynamicBytesFile.Init(ctx, creds, linux.UNNAMED_MAJOR, fs.devMinor, fs.NextIno(), c, linux.FileMode(0444))
+	return c
+}
+
+// cpuList returns the CPU list format of the CPUs in [first, last], as
+// printed by the %*pbl format of Linux's vsprintf.
+func cpuList(first, last uint) string {
+	if first == last {
+		return fmt.Sprintf("%d", first)
+	}
+	return fmt.Sprintf("%d-%d", first, last)
+}
+
+// cpuMap returns the CPU mask format of the CPUs in [first, last] in a mask
+// of nbits CPUs, as printed by the %*pb format of Linux's vsprintf: 32-bit
+// hexadecimal words separated by commas, most significant first.
+func cpuMap(nbits, first, last uint) string {
+	var words []string
+	for hi := (nbits + 31) / 32 * 32; hi > 0; hi -= 32 {
+		lo := hi - 32
+		var word uint32
+		for bit := lo; bit < hi; bit++ {
+			if bit >= first && bit <= last {
+				word |= 1 << (bit - lo)
+			}
+		}
+		// Only the first word is truncated to the mask width.
+		width := 8
+		if hi > nbits {
+			width = int((nbits - lo + 3) / 4)
+		}
+		words = append(words, fmt.Sprintf("%0*x", width, word))
+	}
+	return strings.Join(words, ",")
+}
@@ -0,0 +1,51 @@
+// Copyright 2021 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// +build amd64
+
+package sys
+
+import (
+	"gvisor.dev/gvisor/pkg/cpuid"
+)
+
+// cpuCaches returns the caches described by fs.
+func cpuCaches(fs *cpuid.FeatureSet) []cpuCache {
+	var caches []cpuCache
+	for _, c := range fs.Caches {
+		var typ string
+		switch c.Type {
+		case cpuid.CacheData:
+			typ = "Data"
+		case cpuid.CacheInstruction:
+			typ = "Instruction"
+		case cpuid.CacheUnified:
+			typ = "Unified"
+		default:
+			continue
+		}
+		caches = append(caches, cpuCache{
+			level:      c.Level,
+			typ:        typ,
+			lineSize:   fs.CacheLine,
+			ways:       c.Ways,
+			sets:       c.Sets,
+			partitions: c.Partitions,
+			// Last level caches are shared by all cores of a package, and
+			// each CPU is shown as a core of a single package.
+			shared: c.Level >= 3,
+		})
+	}
+	return caches
+}
@@ -0,0 +1,27 @@
+// Copyright 2021 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// +build arm64
+
+package sys
+
+import (
+	"gvisor.dev/gvisor/pkg/cpuid"
+)
+
+// cpuCaches returns the caches described by fs. Caches are not described by
+// the arm64 feature set, so no caches are shown.
+func cpuCaches(fs *cpuid.FeatureSet) []cpuCache {
+	return nil
+}
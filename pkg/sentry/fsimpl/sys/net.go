@@ -0,0 +1,115 @@
+// Copyright 2021 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sys
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+
+	"gvisor.dev/gvisor/pkg/abi/linux"
+	"gvisor.dev/gvisor/pkg/context"
+	"gvisor.dev/gvisor/pkg/sentry/fsimpl/kernfs"
+	"gvisor.dev/gvisor/pkg/sentry/inet"
+	"gvisor.dev/gvisor/pkg/sentry/kernel/auth"
+	"gvisor.dev/gvisor/pkg/syserror"
+)
+
+// netDirs returns the directories of the network interfaces of the network
+// stack of the mounting context, as shown in /sys/devices/virtual/net, and
+// the symlinks to them shown in /sys/class/net. Both are keyed by interface
+// name.
+//
+// Interfaces are listed when the filesystem is mounted, but the attributes
+// that may change, such as the MTU, are read from the stack.
+func netDirs(ctx context.Context, fs *filesystem, creds *auth.Credentials) (devices, class map[string]kernfs.Inode) {
+	devices = make(map[string]kernfs.Inode)
+	class = make(map[string]kernfs.Inode)
+	stack := inet.StackFromContext(ctx)
+	if stack == nil {
+		return devices, class
+	}
+	static := func(data string) kernfs.Inode {
+		return fs.newStaticFile(ctx, creds, linux.FileMode(0444), data)
+	}
+	for idx, iface := range stack.Interfaces() {
+		addr := make([]string, len(iface.Addr))
+		for i, b := range iface.Addr {
+			addr[i] = fmt.Sprintf("%02x", b)
+		}
+		devices[iface.Name] = fs.newDir(ctx, creds, defaultSysDirMode, map[string]kernfs.Inode{
+			"addr_len":     static(fmt.Sprintf("%d\n", len(iface.Addr))),
+			"address":      static(strings.Join(addr, ":") + "\n"),
+			"flags":        fs.newNetFile(ctx, creds, stack, idx, "flags"),
+			"ifindex":      static(fmt.Sprintf("%d\n", idx)),
+			"iflink":       static(fmt.Sprintf("%d\n", idx)),
+			"mtu":          fs.newNetFile(ctx, creds, stack, idx, "mtu"),
+			"operstate":    fs.newNetFile(ctx, creds, stack, idx, "operstate"),
+			"tx_queue_len": static("1000\n"),
+			"type":         static(fmt.Sprintf("%d\n", iface.DeviceType)),
+		})
+		class[iface.Name] = fs.newSymlink(ctx, creds, "../../devices/virtual/net/"+iface.Name)
+	}
+	return devices, class
+}
+
+// netFile implements kernfs.Inode for the attributes of network interfaces
+// that are read from the network stack.
+//
+// +stateify savable
+type netFile struct {
+	implStatFS
+	kernfs.DynamicBytesFile
+
+	stack inet.Stack
+	idx   int32
+
+	// attr is the name of the attribute.
+	attr string
+}
+
+// Generate implements vfs.DynamicBytesSource.Generate.
+func (f *netFile) Generate(ctx context.Context, buf *bytes.Buffer) error {
+	iface, ok := f.stack.Interfaces()[f.idx]
+	if !ok {
+		// The interface has been removed.
+		return syserror.ENODEV
+	}
+	switch f.attr {
+	case "flags":
+		fmt.Fprintf(buf, "0x%x\n", iface.Flags)
+	case "mtu":
+		fmt.Fprintf(buf, "%d\n", iface.MTU)
+	case "operstate":
+		// Linux doesn't track the operational state of loopback devices.
+		switch {
+		case iface.Flags&linux.IFF_LOOPBACK != 0:
+			buf.WriteString("unknown\n")
+		case iface.Flags&linux.IFF_UP != 0:
+			buf.WriteString("up\n")
+		default:
+			buf.WriteString("down\n")
+		}
+	default:
+		panic(fmt.Sprintf("unknown network interface attribute %q", f.attr))
+	}
+	return nil
+}
+
+func (fs *filesystem) newNetFile(ctx context.Context, creds *auth.Credentials, stack inet.Stack, idx int32, attr string) kernfs.Inode {
+	f := &netFile{stack: stack, idx: idx, attr: attr}
+	f.DynamicBytesFile.Init(ctx, creds, linux.UNNAMED_MAJOR, fs.devMinor, fs.NextIno(), f, linux.FileMode(0444))
+	return f
+}
@@ -25,7 +25,6 @@ import (
 	"gvisor.dev/gvisor/pkg/coverage"
 	"gvisor.dev/gvisor/pkg/log"
 	"gvisor.dev/gvisor/pkg/sentry/fsimpl/kernfs"
-	"gvisor.dev/gvisor/pkg/sentry/kernel"
 	"gvisor.dev/gvisor/pkg/sentry/kernel/auth"
 	"gvisor.dev/gvisor/pkg/sentry/vfs"
 	"gvisor.dev/gvisor/pkg/syserror"
@@ -84,43 +83,40 @@ func (fsType FilesystemType) GetFilesystem(ctx context.Context, vfsObj *vfs.Virt
 	fs.MaxCachedDentries = maxCachedDentries
 	fs.VFSFilesystem().Init(vfsObj, &fsType, fs)
 
+	blockDevices, block, devBlock := blockDirs(ctx, fs, creds)
+	netDevices, classNet := netDirs(ctx, fs, creds)
 	root := fs.newDir(ctx, creds, defaultSysDirMode, map[string]kernfs.Inode{
-		"block": fs.newDir(ctx, creds, defaultSysDirMode, nil),
+		"block": fs.newDir(ctx, creds, defaultSysDirMode, block),
 		"bus":   fs.newDir(ctx, creds, defaultSysDirMode, nil),
 		"class": fs.newDir(ctx, creds, defaultSysDirMode, map[string]kernfs.Inode{
+			"net":          fs.newDir(ctx, creds, defaultSysDirMode, classNet),
 			"power_supply": fs.newDir(ctx, creds, defaultSysDirMode, nil),
 		}),
-		"dev": fs.newDir(ctx, creds, defaultSysDirMode, nil),
+		"dev": fs.newDir(ctx, creds, defaultSysDirMode, map[string]kernfs.Inode{
+			"block": fs.newDir(ctx, creds, defaultSysDirMode, devBlock),
+		}),
 		"devices": fs.newDir(ctx, creds, defaultSysDirMode, map[string]kernfs.Inode{
 			"system": fs.newDir(ctx, creds, defaultSysDirMode, map[string]kernfs.Inode{
 				"cpu": cpuDir(ctx, fs, creds),
 			}),
+			"virtual": fs.newDir(ctx, creds, defaultSysDirMode, map[string]kernfs.Inode{
+				"block": fs.newDir(ctx, creds, defaultSysDirMode, blockDevices),
+				"net":   fs.newDir(ctx, creds, defaultSysDirMode, netDevices),
+			}),
 		}),
 		"firmware": fs.newDir(ctx, creds, defaultSysDirMode, nil),
-		"fs":       fs.newDir(ctx, creds, defaultSysDirMode, nil),
-		"kernel":   kernelDir(ctx, fs, creds),
-		"module":   fs.newDir(ctx, creds, defaultSysDirMode, nil),
-		"power":    fs.newDir(ctx, creds, defaultSysDirMode, nil),
+		"fs": fs.newDir(ctx, creds, defaultSysDirMode, map[string]kernfs.Inode{
+			"cgroup": fs.newDir(ctx, creds, defaultSysDirMode, nil),
+		}),
+		"kernel": kernelDir(ctx, fs, creds),
+		"module": fs.newDir(ctx, creds, defaultSysDirMode, nil),
+		"power":  fs.newDir(ctx, creds, defaultSysDirMode, nil),
 	})
 	var rootD kernfs.Dentry
 	rootD.InitRoot(&fs.Filesystem, root)
 	return fs.VFSFilesystem(), rootD.VFSDentry(), nil
 }
 
-func cpuDir(ctx context.Context, fs *filesystem, creds *auth.Credentials) kernfs.Inode {
-	k := kernel.KernelFromContext(ctx)
-	maxCPUCores := k.ApplicationCores()
-	children := map[string]kernfs.Inode{
-		"online":   fs.newCPUFile(ctx, creds, maxCPUCores, linux.FileMode(0444)),
-		"possible": fs.newCPUFile(ctx, creds, maxCPUCores, linux.FileMode(0444)),
-		"present":  fs.newCPUFile(ctx, creds, maxCPUCores, linux.FileMode(0444)),
-	}
-	for i := uint(0); i < maxCPUCores; i++ {
-		children[fmt.Sprintf("cpu%d", i)] = fs.newDir(ctx, creds, linux.FileMode(0555), nil)
-	}
-	return fs.newDir(ctx, creds, defaultSysDirMode, children)
-}
-
 func kernelDir(ctx context.Context, fs *filesystem, creds *auth.Credentials) kernfs.Inode {
 	// If kcov is available, set up /sys/kernel/debug/kcov. Technically, debugfs
 	// should be mounted at debug/, but for our purposes, it is sufficient to
@@ -215,6 +211,25 @@ func (fs *filesystem) newCPUFile(ctx context.Context, creds *auth.Credentials, m
 	return c
 }
 
+// staticFile implements kernfs.Inode.
+//
+// +stateify savable
+type staticFile struct {
+	implStatFS
+	kernfs.DynamicBytesFile
+	vfs.StaticData
+}
+
+func (fs *filesystem) newStaticFile(ctx context.Context, creds *auth.Credentials, mode linux.FileMode, data string) kernfs.Inode {
+	s := &staticFile{StaticData: vfs.StaticData{Data: data}}
+	s.DynamicBytesFile.Init(ctx, creds, linux.UNNAMED_MAJOR, fs.devMinor, fs.NextIno(), s, mode)
+	return s
+}
+
+func (fs *filesystem) newSymlink(ctx context.Context, creds *auth.Credentials, target string) kernfs.Inode {
+	return kernfs.NewStaticSymlink(ctx, creds, linux.UNNAMED_MAJOR, fs.devMinor, fs.NextIno(), target)
+}
+
 // +stateify savable
 type implStatFS struct{}
 
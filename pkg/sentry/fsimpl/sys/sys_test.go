@@ -16,22 +16,33 @@ package sys_test
 
 import (
 	"fmt"
+	"io"
 	"testing"
 
 	"github.com/google/go-cmp/cmp"
 	"gvisor.dev/gvisor/pkg/abi/linux"
+	"gvisor.dev/gvisor/pkg/context"
 	"gvisor.dev/gvisor/pkg/sentry/fsimpl/sys"
 	"gvisor.dev/gvisor/pkg/sentry/fsimpl/testutil"
 	"gvisor.dev/gvisor/pkg/sentry/kernel"
 	"gvisor.dev/gvisor/pkg/sentry/kernel/auth"
 	"gvisor.dev/gvisor/pkg/sentry/vfs"
+	"gvisor.dev/gvisor/pkg/syserror"
 )
 
 func newTestSystem(t *testing.T) *testutil.System {
+	return newTestSystemWithKernel(t, bootTestKernel(t))
+}
+
+func bootTestKernel(t *testing.T) *kernel.Kernel {
 	k, err := testutil.Boot()
 	if err != nil {
 		t.Fatalf("Failed to create test kernel: %v", err)
 	}
+	return k
+}
+
+func newTestSystemWithKernel(t *testing.T, k *kernel.Kernel) *testutil.System {
 	ctx := k.SupervisorContext()
 	creds := auth.CredentialsFromContext(ctx)
 	k.VFS().MustRegisterFilesystemType(sys.Name, sys.FilesystemType{}, &vfs.RegisterFilesystemTypeOptions{
@@ -70,6 +81,144 @@ func TestReadCPUFile(t *testing.T) {
 	}
 }
 
+func readFile(t *testing.T, s *testutil.System, path string) string {
+	t.Helper()
+	pop := s.PathOpAtRoot(path)
+	fd, err := s.VFS.OpenAt(s.Ctx, s.Creds, pop, &vfs.OpenOptions{})
+	if err != nil {
+		t.Fatalf("OpenAt(pop:%+v) failed: %v", pop, err)
+	}
+	defer fd.DecRef(s.Ctx)
+	content, err := s.ReadToEnd(fd)
+	if err != nil {
+		t.Fatalf("Read of %q failed: %v", path, err)
+	}
+	return content
+}
+
+func TestReadCPUTopology(t *testing.T) {
+	s := newTestSystem(t)
+	defer s.Destroy()
+	k := kernel.KernelFromContext(s.Ctx)
+	maxCPUCores := k.ApplicationCores()
+
+	for i := uint(0); i < maxCPUCores; i++ {
+		for fname, want := range map[string]string{
+			"core_id":              fmt.Sprintf("%d\n", i),
+			"core_cpus_list":       fmt.Sprintf("%d\n", i),
+			"physical_package_id":  "0\n",
+			"thread_siblings_list": fmt.Sprintf("%d\n", i),
+			"core_siblings_list":   fmt.Sprintf("0-%d\n", maxCPUCores-1),
+		} {
+			if maxCPUCores == 1 && fname == "core_siblings_list" {
+				want = "0\n"
+			}
+			path := fmt.Sprintf("devices/system/cpu/cpu%d/topology/%s", i, fname)
+			if got := readFile(t, s, path); got != want {
+				t.Errorf("Read of %q returned %q, want %q", path, got, want)
+			}
+		}
+	}
+}
+
+func TestReadOfflineCPUs(t *testing.T) {
+	s := newTestSystem(t)
+	defer s.Destroy()
+	k := kernel.KernelFromContext(s.Ctx)
+	maxCPUCores := k.ApplicationCores()
+	if maxCPUCores < 2 {
+		t.Skipf("Need at least 2 CPUs, have %d", maxCPUCores)
+	}
+	if err := k.SetOnlineCores(1); err != nil {
+		t.Fatalf("SetOnlineCores(1) failed: %v", err)
+	}
+	defer k.SetOnlineCores(maxCPUCores)
+
+	offline := fmt.Sprintf("1-%d\n", maxCPUCores-1)
+	if maxCPUCores == 2 {
+		offline = "1\n"
+	}
+	for path, want := range map[string]string{
+		"devices/system/cpu/online":      "0\n",
+		"devices/system/cpu/offline":     offline,
+		"devices/system/cpu/possible":    fmt.Sprintf("0-%d\n", maxCPUCores-1),
+		"devices/system/cpu/cpu1/online": "0\n",
+	} {
+		if got := readFile(t, s, path); got != want {
+			t.Errorf("Read of %q returned %q, want %q", path, got, want)
+		}
+	}
+}
+
+// testDisk implements vfs.Disk.
+type testDisk struct {
+	size int64
+}
+
+func (d *testDisk) ReadAt(ctx context.Context, b []byte, off int64) (int, error) {
+	return 0, io.EOF
+}
+func (d *testDisk) WriteAt(ctx context.Context, b []byte, off int64) (int, error) {
+	return 0, syserror.EROFS
+}
+func (d *testDisk) Size() int64                              { return d.size }
+func (d *testDisk) ReadOnly() bool                           { return true }
+func (d *testDisk) DecRef(ctx context.Context)               {}
+
+// testDiskDevice implements vfs.DiskDevice.
+type testDiskDevice struct {
+	disk *testDisk
+}
+
+func (d *testDiskDevice) Open(ctx context.Context, mnt *vfs.Mount, vfsd *vfs.Dentry, opts vfs.OpenOptions) (*vfs.FileDescription, error) {
+	return nil, syserror.ENXIO
+}
+
+func (d *testDiskDevice) Disk(ctx context.Context) (vfs.Disk, error) {
+	if d.disk == nil {
+		return nil, syserror.ENXIO
+	}
+	return d.disk, nil
+}
+
+func TestReadBlockDevices(t *testing.T) {
+	k := bootTestKernel(t)
+	for minor, dev := range []*testDiskDevice{
+		{disk: &testDisk{size: 1 << 20}},
+		{},
+	} {
+		if err := k.VFS().RegisterDevice(vfs.BlockDevice, 240, uint32(minor), dev, &vfs.RegisterDeviceOptions{
+			Pathname: fmt.Sprintf("test/disk%d", minor),
+		}); err != nil {
+			t.Fatalf("RegisterDevice failed: %v", err)
+		}
+	}
+	s := newTestSystemWithKernel(t, k)
+	defer s.Destroy()
+
+	s.AssertAllDirentTypes(s.ListDirents(s.PathOpAtRoot("block")), map[string]testutil.DirentType{
+		"test!disk0": linux.DT_LNK,
+		"test!disk1": linux.DT_LNK,
+	})
+	s.AssertAllDirentTypes(s.ListDirents(s.PathOpAtRoot("dev/block")), map[string]testutil.DirentType{
+		"240:0": linux.DT_LNK,
+		"240:1": linux.DT_LNK,
+	})
+	for path, want := range map[string]string{
+		"block/test!disk0/dev":              "240:0\n",
+		"block/test!disk0/ro":               "1\n",
+		"block/test!disk0/size":             "2048\n",
+		"dev/block/240:1/dev":               "240:1\n",
+		"dev/block/240:1/ro":                "0\n",
+		"dev/block/240:1/size":              "0\n",
+		"block/test!disk0/queue/rotational": "0\n",
+	} {
+		if got := readFile(t, s, path); got != want {
+			t.Errorf("Read of %q returned %q, want %q", path, got, want)
+		}
+	}
+}
+
 func TestSysRootContainsExpectedEntries(t *testing.T) {
 	s := newTestSystem(t)
 	defer s.Destroy()
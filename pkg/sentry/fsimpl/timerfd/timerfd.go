@@ -16,8 +16,11 @@
 package timerfd
 
 import (
+	"bytes"
+	"fmt"
 	"sync/atomic"
 
+	"gvisor.dev/gvisor/pkg/abi/linux"
 	"gvisor.dev/gvisor/pkg/context"
 	ktime "gvisor.dev/gvisor/pkg/sentry/kernel/time"
 	"gvisor.dev/gvisor/pkg/sentry/vfs"
@@ -39,6 +42,13 @@ type TimerFileDescription struct {
 	events waiter.Queue
 	timer  *ktime.Timer
 
+	// clockID is the ID of the clock of timer. clockID is immutable.
+	clockID int32
+
+	// settimeFlags are the flags passed to the last call to SetTime.
+	// settimeFlags must be accessed using atomic memory operations.
+	settimeFlags int32
+
 	// val is the number of timer expirations since the last successful
 	// call to PRead, or SetTime. val must be accessed using atomic memory
 	// operations.
@@ -48,11 +58,13 @@ type TimerFileDescription struct {
 var _ vfs.FileDescriptionImpl = (*TimerFileDescription)(nil)
 var _ ktime.TimerListener = (*TimerFileDescription)(nil)
 
-// New returns a new timer fd.
-func New(ctx context.Context, vfsObj *vfs.VirtualFilesystem, clock ktime.Clock, flags uint32) (*vfs.FileDescription, error) {
+// New returns a new timer fd using clock, whose ID is clockID.
+func New(ctx context.Context, vfsObj *vfs.VirtualFilesystem, clockID int32, clock ktime.Clock, flags uint32) (*vfs.FileDescription, error) {
 	vd := vfsObj.NewAnonVirtualDentry("[timerfd]")
 	defer vd.DecRef(ctx)
-	tfd := &TimerFileDescription{}
+	tfd := &TimerFileDescription{
+		clockID: clockID,
+	}
 	tfd.timer = ktime.NewTimer(clock, tfd)
 	if err := tfd.vfsfd.Init(tfd, flags, vd.Mount(), vd.Dentry(), &vfs.FileDescriptionOptions{
 		UseDentryMetadata: true,
@@ -96,11 +108,24 @@ func (tfd *TimerFileDescription) GetTime() (ktime.Time, ktime.Setting) {
 
 // SetTime atomically changes the associated Timer's setting, resets the number
 // of expirations to 0, and returns the previous setting and the time at which
-// it was observed.
-func (tfd *TimerFileDescription) SetTime(s ktime.Setting) (ktime.Time, ktime.Setting) {
+// it was observed. flags are the flags passed to timerfd_settime(2), which
+// are only recorded for fdinfo.
+func (tfd *TimerFileDescription) SetTime(s ktime.Setting, flags int32) (ktime.Time, ktime.Setting) {
+	atomic.StoreInt32(&tfd.settimeFlags, flags)
 	return tfd.timer.SwapAnd(s, func() { atomic.StoreUint64(&tfd.val, 0) })
 }
 
+// FDInfo implements vfs.FileDescriptionImplFDInfoExtension.FDInfo, as in
+// Linux's fs/timerfd.c:timerfd_show().
+func (tfd *TimerFileDescription) FDInfo(ctx context.Context, buf *bytes.Buffer) {
+	its := ktime.ItimerspecFromSetting(tfd.GetTime())
+	fmt.Fprintf(buf, "clockid: %d\n", tfd.clockID)
+	fmt.Fprintf(buf, "ticks: %d\n", atomic.LoadUint64(&tfd.val))
+	fmt.Fprintf(buf, "settime flags: 0%o\n", atomic.LoadInt32(&tfd.settimeFlags)&linux.TFD_TIMER_ABSTIME)
+	fmt.Fprintf(buf, "it_value: (%d, %d)\n", its.Value.Sec, its.Value.Nsec)
+	fmt.Fprintf(buf, "it_interval: (%d, %d)\n", its.Interval.Sec, its.Interval.Nsec)
+}
+
 // Readiness implements waiter.Waitable.Readiness.
 func (tfd *TimerFileDescription) Readiness(mask waiter.EventMask) waiter.EventMask {
 	var ready waiter.EventMask
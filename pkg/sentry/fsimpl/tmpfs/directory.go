@@ -148,6 +148,8 @@ func (fd *directoryFD) IterDirents(ctx context.Context, cb vfs.IterDirentsCallba
 		fd.off++
 	}
 
+	// If dir's key is absent, show its children by their no-key names.
+	hasKey := fs.hasKey(dir.inode.encryption)
 	var child *dentry
 	if fd.iter == nil {
 		// Start iteration at the beginning of dir.
@@ -161,8 +163,12 @@ func (fd *directoryFD) IterDirents(ctx context.Context, cb vfs.IterDirentsCallba
 	for child != nil {
 		// Skip other directoryFD iterators.
 		if child.inode != nil {
+			name := child.name
+			if !hasKey {
+				name = noKeyEncoding(&dir.inode.encryptionNonce, name)
+			}
 			if err := cb.Handle(vfs.Dirent{
-				Name:    child.name,
+				Name:    name,
 				Type:    child.inode.direntType(),
 				Ino:     child.inode.ino,
 				NextOff: fd.off + 1,
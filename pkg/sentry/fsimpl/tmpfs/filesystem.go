@@ -72,7 +72,7 @@ afterSymlink:
 	if len(name) > linux.NAME_MAX {
 		return nil, syserror.ENAMETOOLONG
 	}
-	child, ok := dir.childMap[name]
+	child, ok := dir.lookupChildLocked(name)
 	if !ok {
 		return nil, syserror.ENOENT
 	}
@@ -82,7 +82,7 @@ afterSymlink:
 	if symlink, ok := child.inode.impl.(*symlink); ok && rp.ShouldFollowSymlink() {
 		// Symlink traversal updates access time.
 		child.inode.touchAtime(rp.Mount())
-		if err := rp.HandleSymlink(symlink.target); err != nil {
+		if err := rp.HandleSymlink(symlink.visibleTarget()); err != nil {
 			return nil, err
 		}
 		goto afterSymlink // don't check the current directory again
@@ -166,7 +166,7 @@ func (fs *filesystem) doCreateAt(ctx context.Context, rp *vfs.ResolvingPath, dir
 	if len(name) > linux.NAME_MAX {
 		return syserror.ENAMETOOLONG
 	}
-	if _, ok := parentDir.childMap[name]; ok {
+	if _, ok := parentDir.lookupChildLocked(name); ok {
 		return syserror.EEXIST
 	}
 	if !dir && rp.MustBeDir() {
@@ -186,6 +186,9 @@ func (fs *filesystem) doCreateAt(ctx context.Context, rp *vfs.ResolvingPath, dir
 	if err := parentDir.inode.checkPermissions(rp.Credentials(), vfs.MayWrite); err != nil {
 		return err
 	}
+	if err := parentDir.mayModifyLocked(); err != nil {
+		return err
+	}
 	if err := create(parentDir, name); err != nil {
 		return err
 	}
@@ -256,13 +259,24 @@ func (fs *filesystem) LinkAt(ctx context.Context, rp *vfs.ResolvingPath, vd vfs.
 		if err := vfs.MayLink(auth.CredentialsFromContext(ctx), linux.FileMode(atomic.LoadUint32(&i.mode)), auth.KUID(atomic.LoadUint32(&i.uid)), auth.KGID(atomic.LoadUint32(&i.gid))); err != nil {
 			return err
 		}
-		if i.nlink == 0 {
+		if i.nlink == 0 && !i.linkable {
 			return syserror.ENOENT
 		}
 		if i.nlink == maxLinks {
 			return syserror.EMLINK
 		}
-		i.incLinksLocked()
+		if err := parentDir.mayContainLocked(i); err != nil {
+			return err
+		}
+		if i.nlink == 0 {
+			// i was created by open(O_TMPFILE); it is now reachable in the
+			// filesystem tree, which holds a reference on it.
+			i.incRef()
+			atomic.StoreUint32(&i.nlink, 1)
+			i.linkable = false
+		} else {
+			i.incLinksLocked()
+		}
 		i.watches.Notify(ctx, "", linux.IN_ATTRIB, 0, vfs.InodeEvent, false /* unlinked */)
 		parentDir.insertChildLocked(fs.newDentry(i), name)
 		return nil
@@ -276,8 +290,12 @@ func (fs *filesystem) MkdirAt(ctx context.Context, rp *vfs.ResolvingPath, opts v
 		if parentDir.inode.nlink == maxLinks {
 			return syserror.EMLINK
 		}
+		if err := fs.checkInodeAvailable(); err != nil {
+			return err
+		}
 		parentDir.inode.incLinksLocked() // from child's ".."
 		childDir := fs.newDirectory(creds.EffectiveKUID, creds.EffectiveKGID, opts.Mode)
+		parentDir.inheritEncryptionLocked(&childDir.inode)
 		parentDir.insertChildLocked(&childDir.dentry, name)
 		return nil
 	})
@@ -286,6 +304,9 @@ func (fs *filesystem) MkdirAt(ctx context.Context, rp *vfs.ResolvingPath, opts v
 // MknodAt implements vfs.FilesystemImpl.MknodAt.
 func (fs *filesystem) MknodAt(ctx context.Context, rp *vfs.ResolvingPath, opts vfs.MknodOptions) error {
 	return fs.doCreateAt(ctx, rp, false /* dir */, func(parentDir *directory, name string) error {
+		if err := fs.checkInodeAvailable(); err != nil {
+			return err
+		}
 		creds := rp.Credentials()
 		var childInode *inode
 		switch opts.Mode.FileType() {
@@ -302,6 +323,7 @@ func (fs *filesystem) MknodAt(ctx context.Context, rp *vfs.ResolvingPath, opts v
 		default:
 			return syserror.EINVAL
 		}
+		parentDir.inheritEncryptionLocked(childInode)
 		child := fs.newDentry(childInode)
 		parentDir.insertChildLocked(child, name)
 		return nil
@@ -311,8 +333,7 @@ func (fs *filesystem) MknodAt(ctx context.Context, rp *vfs.ResolvingPath, opts v
 // OpenAt implements vfs.FilesystemImpl.OpenAt.
 func (fs *filesystem) OpenAt(ctx context.Context, rp *vfs.ResolvingPath, opts vfs.OpenOptions) (*vfs.FileDescription, error) {
 	if opts.Flags&linux.O_TMPFILE != 0 {
-		// Not yet supported.
-		return nil, syserror.EOPNOTSUPP
+		return fs.openTmpfile(ctx, rp, &opts)
 	}
 
 	// Handle O_CREAT and !O_CREAT separately, since in the latter case we
@@ -375,7 +396,7 @@ afterTrailingSymlink:
 		return nil, syserror.ENAMETOOLONG
 	}
 	// Determine whether or not we need to create a file.
-	child, ok := parentDir.childMap[name]
+	child, ok := parentDir.lookupChildLocked(name)
 	if !ok {
 		// Already checked for searchability above; now check for writability.
 		if err := parentDir.inode.checkPermissions(rp.Credentials(), vfs.MayWrite); err != nil {
@@ -385,9 +406,17 @@ afterTrailingSymlink:
 			return nil, err
 		}
 		defer rp.Mount().EndWrite()
+		if err := fs.checkInodeAvailable(); err != nil {
+			return nil, err
+		}
+		if err := parentDir.mayModifyLocked(); err != nil {
+			return nil, err
+		}
 		// Create and open the child.
 		creds := rp.Credentials()
-		child := fs.newDentry(fs.newRegularFile(creds.EffectiveKUID, creds.EffectiveKGID, opts.Mode))
+		childInode := fs.newRegularFile(creds.EffectiveKUID, creds.EffectiveKGID, opts.Mode)
+		parentDir.inheritEncryptionLocked(childInode)
+		child := fs.newDentry(childInode)
 		parentDir.insertChildLocked(child, name)
 		child.IncRef()
 		defer child.DecRef(ctx)
@@ -411,7 +440,7 @@ afterTrailingSymlink:
 	if symlink, ok := child.inode.impl.(*symlink); ok && rp.ShouldFollowSymlink() {
 		// Symlink traversal updates access time.
 		child.inode.touchAtime(rp.Mount())
-		if err := rp.HandleSymlink(symlink.target); err != nil {
+		if err := rp.HandleSymlink(symlink.visibleTarget()); err != nil {
 			return nil, err
 		}
 		start = &parentDir.dentry
@@ -426,6 +455,70 @@ afterTrailingSymlink:
 	return child.open(ctx, rp, &opts, false)
 }
 
+// openTmpfile implements OpenAt for O_TMPFILE, creating an unnamed regular
+// file in the directory at rp.
+func (fs *filesystem) openTmpfile(ctx context.Context, rp *vfs.ResolvingPath, opts *vfs.OpenOptions) (*vfs.FileDescription, error) {
+	fs.mu.Lock()
+	unlocked := false
+	unlock := func() {
+		if !unlocked {
+			fs.mu.Unlock()
+			unlocked = true
+		}
+	}
+	defer unlock()
+	d, err := resolveLocked(ctx, rp)
+	if err != nil {
+		return nil, err
+	}
+	dir, ok := d.inode.impl.(*directory)
+	if !ok {
+		return nil, syserror.ENOTDIR
+	}
+	if err := dir.inode.checkPermissions(rp.Credentials(), vfs.MayWrite|vfs.MayExec); err != nil {
+		return nil, err
+	}
+	if dir.dentry.vfsd.IsDead() {
+		return nil, syserror.ENOENT
+	}
+	mnt := rp.Mount()
+	if err := mnt.CheckBeginWrite(); err != nil {
+		return nil, err
+	}
+	defer mnt.EndWrite()
+	if err := fs.checkInodeAvailable(); err != nil {
+		return nil, err
+	}
+	if err := dir.mayModifyLocked(); err != nil {
+		return nil, err
+	}
+
+	// The new file has no links, so the reference returned by
+	// newRegularFile() is dropped once the file has been opened, leaving the
+	// file alive only as long as it is open or until it is linked into the
+	// filesystem tree by LinkAt.
+	creds := rp.Credentials()
+	childInode := fs.newRegularFile(creds.EffectiveKUID, creds.EffectiveKGID, opts.Mode)
+	childInode.nlink = 0
+	childInode.linkable = opts.Flags&linux.O_EXCL == 0
+	dir.inheritEncryptionLocked(childInode)
+	child := fs.newDentry(childInode)
+	defer child.DecRef(ctx)
+	// child is never inserted into dir, but has dir as its parent for the
+	// purposes of inotify and path generation.
+	child.parent = &dir.dentry
+	child.name = fmt.Sprintf("#%d", childInode.ino)
+	vfsObj := rp.VirtualFilesystem()
+	mntns := vfs.MountNamespaceFromContext(ctx)
+	defer mntns.DecRef(ctx)
+	if err := vfsObj.PrepareDeleteDentry(mntns, &child.vfsd); err != nil {
+		return nil, err
+	}
+	vfsObj.CommitDeleteDentry(ctx, &child.vfsd)
+	unlock()
+	return child.open(ctx, rp, opts, true /* afterCreate */)
+}
+
 // Preconditions: The caller must hold no locks (since opening pipes may block
 // indefinitely).
 func (d *dentry) open(ctx context.Context, rp *vfs.ResolvingPath, opts *vfs.OpenOptions, afterCreate bool) (*vfs.FileDescription, error) {
@@ -437,6 +530,9 @@ func (d *dentry) open(ctx context.Context, rp *vfs.ResolvingPath, opts *vfs.Open
 	}
 	switch impl := d.inode.impl.(type) {
 	case *regularFile:
+		if !d.inode.fs.hasKey(d.inode.encryption) {
+			return nil, syserror.ENOKEY
+		}
 		var fd regularFileFD
 		fd.LockFD.Init(&d.inode.locks)
 		if err := fd.vfsfd.Init(&fd, opts.Flags, rp.Mount(), &d.vfsd, &vfs.FileDescriptionOptions{AllowDirectIO: true}); err != nil {
@@ -491,15 +587,17 @@ func (fs *filesystem) ReadlinkAt(ctx context.Context, rp *vfs.ResolvingPath) (st
 		return "", syserror.EINVAL
 	}
 	symlink.inode.touchAtime(rp.Mount())
-	return symlink.target, nil
+	return symlink.visibleTarget(), nil
 }
 
 // RenameAt implements vfs.FilesystemImpl.RenameAt.
 func (fs *filesystem) RenameAt(ctx context.Context, rp *vfs.ResolvingPath, oldParentVD vfs.VirtualDentry, oldName string, opts vfs.RenameOptions) error {
-	if opts.Flags != 0 {
-		// TODO(b/145974740): Support renameat2 flags.
+	if opts.Flags&^(linux.RENAME_NOREPLACE|linux.RENAME_EXCHANGE|linux.RENAME_WHITEOUT) != 0 {
 		return syserror.EINVAL
 	}
+	noReplace := opts.Flags&linux.RENAME_NOREPLACE != 0
+	exchange := opts.Flags&linux.RENAME_EXCHANGE != 0
+	whiteout := opts.Flags&linux.RENAME_WHITEOUT != 0
 
 	// Resolve newParent first to verify that it's on this Mount.
 	fs.mu.Lock()
@@ -510,6 +608,9 @@ func (fs *filesystem) RenameAt(ctx context.Context, rp *vfs.ResolvingPath, oldPa
 	}
 	newName := rp.Component()
 	if newName == "." || newName == ".." {
+		if noReplace {
+			return syserror.EEXIST
+		}
 		return syserror.EBUSY
 	}
 	mnt := rp.Mount()
@@ -522,14 +623,15 @@ func (fs *filesystem) RenameAt(ctx context.Context, rp *vfs.ResolvingPath, oldPa
 	defer mnt.EndWrite()
 
 	oldParentDir := oldParentVD.Dentry().Impl().(*dentry).inode.impl.(*directory)
-	if err := oldParentDir.inode.checkPermissions(rp.Credentials(), vfs.MayWrite|vfs.MayExec); err != nil {
+	creds := rp.Credentials()
+	if err := oldParentDir.inode.checkPermissions(creds, vfs.MayWrite|vfs.MayExec); err != nil {
 		return err
 	}
-	renamed, ok := oldParentDir.childMap[oldName]
+	renamed, ok := oldParentDir.lookupChildLocked(oldName)
 	if !ok {
 		return syserror.ENOENT
 	}
-	if err := oldParentDir.mayDelete(rp.Credentials(), renamed); err != nil {
+	if err := oldParentDir.mayDelete(creds, renamed); err != nil {
 		return err
 	}
 	// Note that we don't need to call rp.CheckMount(), since if renamed is a
@@ -541,23 +643,58 @@ func (fs *filesystem) RenameAt(ctx context.Context, rp *vfs.ResolvingPath, oldPa
 		}
 		if oldParentDir != newParentDir {
 			// Writability is needed to change renamed's "..".
-			if err := renamed.inode.checkPermissions(rp.Credentials(), vfs.MayWrite); err != nil {
+			if err := renamed.inode.checkPermissions(creds, vfs.MayWrite); err != nil {
 				return err
 			}
 		}
 	} else {
-		if opts.MustBeDir || rp.MustBeDir() {
+		if opts.MustBeDir || (!exchange && rp.MustBeDir()) {
 			return syserror.ENOTDIR
 		}
 	}
 
-	if err := newParentDir.inode.checkPermissions(rp.Credentials(), vfs.MayWrite|vfs.MayExec); err != nil {
+	if err := newParentDir.inode.checkPermissions(creds, vfs.MayWrite|vfs.MayExec); err != nil {
 		return err
 	}
+	if err := oldParentDir.mayModifyLocked(); err != nil {
+		return err
+	}
+	if err := newParentDir.mayModifyLocked(); err != nil {
+		return err
+	}
+	if oldParentDir != newParentDir {
+		if err := newParentDir.mayContainLocked(renamed.inode); err != nil {
+			return err
+		}
+	}
 	replaced, ok := newParentDir.childMap[newName]
 	if ok {
+		if noReplace {
+			return syserror.EEXIST
+		}
 		replacedDir, ok := replaced.inode.impl.(*directory)
-		if ok {
+		if exchange {
+			if err := newParentDir.mayDelete(creds, replaced); err != nil {
+				return err
+			}
+			if ok {
+				if replaced == &oldParentDir.dentry || genericIsAncestorDentry(replaced, &oldParentDir.dentry) {
+					return syserror.EINVAL
+				}
+				if oldParentDir != newParentDir {
+					if err := replaced.inode.checkPermissions(creds, vfs.MayWrite); err != nil {
+						return err
+					}
+				}
+			} else if rp.MustBeDir() {
+				return syserror.ENOTDIR
+			}
+			if oldParentDir != newParentDir {
+				if err := oldParentDir.mayContainLocked(replaced.inode); err != nil {
+					return err
+				}
+			}
+		} else if ok {
 			if !renamed.inode.isDir() {
 				return syserror.EISDIR
 			}
@@ -573,6 +710,9 @@ func (fs *filesystem) RenameAt(ctx context.Context, rp *vfs.ResolvingPath, oldPa
 			}
 		}
 	} else {
+		if exchange {
+			return syserror.ENOENT
+		}
 		if renamed.inode.isDir() && newParentDir.inode.nlink == maxLinks {
 			return syserror.EMLINK
 		}
@@ -590,6 +730,11 @@ func (fs *filesystem) RenameAt(ctx context.Context, rp *vfs.ResolvingPath, oldPa
 	if renamed == replaced {
 		return nil
 	}
+	if whiteout {
+		if err := fs.checkInodeAvailable(); err != nil {
+			return err
+		}
+	}
 	vfsObj := rp.VirtualFilesystem()
 	mntns := vfs.MountNamespaceFromContext(ctx)
 	defer mntns.DecRef(ctx)
@@ -600,6 +745,32 @@ func (fs *filesystem) RenameAt(ctx context.Context, rp *vfs.ResolvingPath, oldPa
 	if err := vfsObj.PrepareRenameDentry(mntns, &renamed.vfsd, replacedVFSD); err != nil {
 		return err
 	}
+	if exchange {
+		oldParentDir.removeChildLocked(renamed)
+		newParentDir.removeChildLocked(replaced)
+		oldParentDir.insertChildLocked(replaced, oldName)
+		newParentDir.insertChildLocked(renamed, newName)
+		vfsObj.CommitRenameExchangeDentry(&renamed.vfsd, &replaced.vfsd)
+		oldParentDir.inode.touchCMtime()
+		if oldParentDir != newParentDir {
+			if renamed.inode.isDir() != replaced.inode.isDir() {
+				if renamed.inode.isDir() {
+					oldParentDir.inode.decLinksLocked(ctx)
+					newParentDir.inode.incLinksLocked()
+				} else {
+					newParentDir.inode.decLinksLocked(ctx)
+					oldParentDir.inode.incLinksLocked()
+				}
+			}
+			newParentDir.inode.touchCMtime()
+		}
+		renamed.inode.touchCtime()
+		replaced.inode.touchCtime()
+
+		vfs.InotifyRename(ctx, &renamed.inode.watches, &oldParentDir.inode.watches, &newParentDir.inode.watches, oldName, newName, renamed.inode.isDir())
+		vfs.InotifyRename(ctx, &replaced.inode.watches, &newParentDir.inode.watches, &oldParentDir.inode.watches, newName, oldName, replaced.inode.isDir())
+		return nil
+	}
 	if replaced != nil {
 		newParentDir.removeChildLocked(replaced)
 		if replaced.inode.isDir() {
@@ -611,6 +782,13 @@ func (fs *filesystem) RenameAt(ctx context.Context, rp *vfs.ResolvingPath, oldPa
 	}
 	oldParentDir.removeChildLocked(renamed)
 	newParentDir.insertChildLocked(renamed, newName)
+	if whiteout {
+		// Replace renamed with a whiteout, as in Linux's
+		// mm/shmem.c:shmem_whiteout().
+		whiteoutInode := fs.newDeviceFile(creds.EffectiveKUID, creds.EffectiveKGID, linux.WHITEOUT_MODE, vfs.CharDevice, linux.WHITEOUT_DEV, linux.WHITEOUT_DEV)
+		oldParentDir.inheritEncryptionLocked(whiteoutInode)
+		oldParentDir.insertChildLocked(fs.newDentry(whiteoutInode), oldName)
+	}
 	vfsObj.CommitRenameReplaceDentry(ctx, &renamed.vfsd, replacedVFSD)
 	oldParentDir.inode.touchCMtime()
 	if oldParentDir != newParentDir {
@@ -644,7 +822,7 @@ func (fs *filesystem) RmdirAt(ctx context.Context, rp *vfs.ResolvingPath) error
 	if name == ".." {
 		return syserror.ENOTEMPTY
 	}
-	child, ok := parentDir.childMap[name]
+	child, ok := parentDir.lookupChildLocked(name)
 	if !ok {
 		return syserror.ENOENT
 	}
@@ -720,14 +898,19 @@ func (fs *filesystem) StatFSAt(ctx context.Context, rp *vfs.ResolvingPath) (linu
 	if _, err := resolveLocked(ctx, rp); err != nil {
 		return linux.Statfs{}, err
 	}
-	return globalStatfs, nil
+	return fs.statFS(), nil
 }
 
 // SymlinkAt implements vfs.FilesystemImpl.SymlinkAt.
 func (fs *filesystem) SymlinkAt(ctx context.Context, rp *vfs.ResolvingPath, target string) error {
 	return fs.doCreateAt(ctx, rp, false /* dir */, func(parentDir *directory, name string) error {
+		if err := fs.checkInodeAvailable(); err != nil {
+			return err
+		}
 		creds := rp.Credentials()
-		child := fs.newDentry(fs.newSymlink(creds.EffectiveKUID, creds.EffectiveKGID, 0777, target))
+		childInode := fs.newSymlink(creds.EffectiveKUID, creds.EffectiveKGID, 0777, target)
+		parentDir.inheritEncryptionLocked(childInode)
+		child := fs.newDentry(childInode)
 		parentDir.insertChildLocked(child, name)
 		return nil
 	})
@@ -748,7 +931,7 @@ func (fs *filesystem) UnlinkAt(ctx context.Context, rp *vfs.ResolvingPath) error
 	if name == "." || name == ".." {
 		return syserror.EISDIR
 	}
-	child, ok := parentDir.childMap[name]
+	child, ok := parentDir.lookupChildLocked(name)
 	if !ok {
 		return syserror.ENOENT
 	}
@@ -0,0 +1,584 @@
+// Copyright 2020 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tmpfs
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"sync/atomic"
+
+	"gvisor.dev/gvisor/pkg/abi/linux"
+	"gvisor.dev/gvisor/pkg/context"
+	"gvisor.dev/gvisor/pkg/marshal"
+	"gvisor.dev/gvisor/pkg/marshal/primitive"
+	"gvisor.dev/gvisor/pkg/rand"
+	"gvisor.dev/gvisor/pkg/sentry/kernel/auth"
+	"gvisor.dev/gvisor/pkg/sentry/vfs"
+	"gvisor.dev/gvisor/pkg/syserror"
+	"gvisor.dev/gvisor/pkg/usermem"
+)
+
+// This file implements the ioctls of Linux's filesystem-level encryption
+// (fscrypt, Documentation/filesystems/fscrypt.rst), so that applications
+// that set up encrypted directories for their scratch space can run on
+// tmpfs.
+//
+// tmpfs stores file contents and names in sentry memory, which the
+// application can't access, so they are not actually encrypted. What is
+// emulated is their visibility: while the master key of an encrypted
+// directory is absent from the filesystem, the names of its children are
+// shown in an encoded form, its regular files can't be opened or truncated,
+// and no files can be created in it. Keys can only be provided as raw keys by
+// FS_IOC_ADD_ENCRYPTION_KEY, since keyctl(2) is not implemented; v1 policies
+// can be used with keys added by key descriptor, which requires
+// CAP_SYS_ADMIN as in Linux.
+
+// minEncryptionKeySize is the minimum size of a master key, in bytes. It is
+// FSCRYPT_MIN_KEY_SIZE in fs/crypto/fscrypt_private.h.
+const minEncryptionKeySize = 16
+
+// encryptionPolicy is the encryption policy of an inode.
+//
+// +stateify savable
+type encryptionPolicy struct {
+	version       uint8
+	contentsMode  uint8
+	filenamesMode uint8
+	flags         uint8
+
+	// key is the master key descriptor (in its first
+	// FSCRYPT_KEY_DESCRIPTOR_SIZE bytes) for v1 policies, or the master key
+	// identifier for v2 policies.
+	key [linux.FSCRYPT_KEY_IDENTIFIER_SIZE]byte
+}
+
+// valid returns true if p is a policy that Linux would accept.
+func (p *encryptionPolicy) valid() bool {
+	switch {
+	case p.contentsMode == linux.FSCRYPT_MODE_AES_256_XTS && p.filenamesMode == linux.FSCRYPT_MODE_AES_256_CTS:
+	case p.contentsMode == linux.FSCRYPT_MODE_AES_128_CBC && p.filenamesMode == linux.FSCRYPT_MODE_AES_128_CTS:
+	case p.contentsMode == linux.FSCRYPT_MODE_ADIANTUM && p.filenamesMode == linux.FSCRYPT_MODE_ADIANTUM:
+	default:
+		return false
+	}
+	if p.version == linux.FSCRYPT_POLICY_V1 {
+		return p.flags&^(linux.FSCRYPT_POLICY_FLAGS_PAD_MASK|linux.FSCRYPT_POLICY_FLAG_DIRECT_KEY) == 0
+	}
+	if p.flags&^(linux.FSCRYPT_POLICY_FLAGS_PAD_MASK|linux.FSCRYPT_POLICY_FLAG_DIRECT_KEY|linux.FSCRYPT_POLICY_FLAG_IV_INO_LBLK_64|linux.FSCRYPT_POLICY_FLAG_IV_INO_LBLK_32) != 0 {
+		return false
+	}
+	// At most one of the key derivation flags may be set.
+	kdf := p.flags &^ linux.FSCRYPT_POLICY_FLAGS_PAD_MASK
+	return kdf&(kdf-1) == 0
+}
+
+func (p *encryptionPolicy) keySpec() encryptionKeySpec {
+	if p.version == linux.FSCRYPT_POLICY_V1 {
+		return encryptionKeySpec{typ: linux.FSCRYPT_KEY_SPEC_TYPE_DESCRIPTOR, id: p.key}
+	}
+	return encryptionKeySpec{typ: linux.FSCRYPT_KEY_SPEC_TYPE_IDENTIFIER, id: p.key}
+}
+
+// encryptionKeySpec identifies a master key.
+//
+// +stateify savable
+type encryptionKeySpec struct {
+	typ uint32
+	id  [linux.FSCRYPT_KEY_IDENTIFIER_SIZE]byte
+}
+
+// encryptionKey represents a master key added to a filesystem.
+//
+// +stateify savable
+type encryptionKey struct {
+	// users is the set of users who have added the key. Keys specified by
+	// descriptor are not tracked per user, so users is only used for keys
+	// specified by identifier.
+	users map[auth.KUID]struct{}
+}
+
+// keyIdentifier returns the identifier of the given raw master key, derived
+// as in fs/crypto/keyring.c:add_master_key(): using HKDF-SHA512 with an empty
+// salt and the HKDF_CONTEXT_KEY_IDENTIFIER application-specific info.
+func keyIdentifier(raw []byte) [linux.FSCRYPT_KEY_IDENTIFIER_SIZE]byte {
+	extract := hmac.New(sha512.New, make([]byte, sha512.Size))
+	extract.Write(raw)
+	expand := hmac.New(sha512.New, extract.Sum(nil))
+	expand.Write([]byte("fscrypt\x00"))
+	expand.Write([]byte{1 /* HKDF_CONTEXT_KEY_IDENTIFIER */, 1 /* counter */})
+	var id [linux.FSCRYPT_KEY_IDENTIFIER_SIZE]byte
+	copy(id[:], expand.Sum(nil))
+	return id
+}
+
+// noKeyEncoding returns the form in which s, which belongs to a file with the
+// given nonce, is shown while the file's master key is absent.
+func noKeyEncoding(nonce *[linux.FSCRYPT_FILE_NONCE_SIZE]byte, s string) string {
+	h := sha256.New()
+	h.Write(nonce[:])
+	h.Write([]byte(s))
+	return base64.RawURLEncoding.EncodeToString(h.Sum(nil))
+}
+
+// hasKey returns true if files using policy p can be accessed, i.e. p is nil
+// or its master key has been added to fs.
+func (fs *filesystem) hasKey(p *encryptionPolicy) bool {
+	if p == nil {
+		return true
+	}
+	fs.keysMu.Lock()
+	defer fs.keysMu.Unlock()
+	_, ok := fs.keys[p.keySpec()]
+	return ok
+}
+
+// setEncryptionLocked sets the encryption policy of i to p and generates its
+// nonce.
+//
+// Preconditions: filesystem.mu must be locked for writing, or i must not be
+// reachable yet.
+func (i *inode) setEncryptionLocked(p *encryptionPolicy) {
+	rand.Read(i.encryptionNonce[:])
+	i.encryption = p
+	atomic.StoreUint32(&i.encrypted, 1)
+}
+
+// inheritEncryptionLocked applies dir's encryption policy, if any, to child,
+// a new inode that is about to be inserted into dir. As in Linux, only
+// regular files, directories and symlinks are encrypted.
+//
+// Preconditions: filesystem.mu must be locked for writing.
+func (dir *directory) inheritEncryptionLocked(child *inode) {
+	p := dir.inode.encryption
+	if p == nil {
+		return
+	}
+	switch child.impl.(type) {
+	case *regularFile, *directory, *symlink:
+		child.setEncryptionLocked(p)
+	}
+}
+
+// mayModifyLocked returns ENOKEY if dir is encrypted and its key is absent, in
+// which case children can't be added to it or renamed.
+//
+// Preconditions: filesystem.mu must be locked.
+func (dir *directory) mayModifyLocked() error {
+	if !dir.inode.fs.hasKey(dir.inode.encryption) {
+		return syserror.ENOKEY
+	}
+	return nil
+}
+
+// mayContainLocked returns EXDEV if child can't be linked or moved into dir,
+// because dir is encrypted and child, which may be encrypted, does not use
+// the same policy. This is analogous to Linux's
+// fs/crypto/policy.c:fscrypt_has_permitted_context().
+//
+// Preconditions: filesystem.mu must be locked.
+func (dir *directory) mayContainLocked(child *inode) error {
+	p := dir.inode.encryption
+	if p == nil {
+		return nil
+	}
+	switch child.impl.(type) {
+	case *regularFile, *directory, *symlink:
+	default:
+		return nil
+	}
+	if child.encryption == nil || *child.encryption != *p {
+		return syserror.EXDEV
+	}
+	return nil
+}
+
+// lookupChildLocked returns the child of dir with the given name. If dir is
+// encrypted and its key is absent, children are named by their no-key names,
+// as returned by IterDirents.
+//
+// Preconditions: filesystem.mu must be locked.
+func (dir *directory) lookupChildLocked(name string) (*dentry, bool) {
+	if dir.inode.fs.hasKey(dir.inode.encryption) {
+		child, ok := dir.childMap[name]
+		return child, ok
+	}
+	for _, child := range dir.childMap {
+		if noKeyEncoding(&dir.inode.encryptionNonce, child.name) == name {
+			return child, true
+		}
+	}
+	return nil, false
+}
+
+// visibleTarget returns the target of s, encoded if s is encrypted and its
+// key is absent.
+func (s *symlink) visibleTarget() string {
+	if s.inode.fs.hasKey(s.inode.encryption) {
+		return s.target
+	}
+	return noKeyEncoding(&s.inode.encryptionNonce, s.target)
+}
+
+func copyContext(ctx context.Context, uio usermem.IO) *primitive.IOCopyContext {
+	return &primitive.IOCopyContext{
+		Ctx:  ctx,
+		IO:   uio,
+		Opts: usermem.IOOpts{AddressSpaceActive: true},
+	}
+}
+
+// setEncryptionPolicy implements FS_IOC_SET_ENCRYPTION_POLICY.
+func (fd *fileDescription) setEncryptionPolicy(ctx context.Context, uio usermem.IO, addr usermem.Addr) error {
+	cc := copyContext(ctx, uio)
+	var version primitive.Uint8
+	if _, err := version.CopyIn(cc, addr); err != nil {
+		return err
+	}
+	var p encryptionPolicy
+	switch version {
+	case linux.FSCRYPT_POLICY_V1:
+		var v1 linux.FscryptPolicyV1
+		if _, err := v1.CopyIn(cc, addr); err != nil {
+			return err
+		}
+		p = encryptionPolicy{
+			version:       v1.Version,
+			contentsMode:  v1.ContentsEncryptionMode,
+			filenamesMode: v1.FilenamesEncryptionMode,
+			flags:         v1.Flags,
+		}
+		copy(p.key[:], v1.MasterKeyDescriptor[:])
+	case linux.FSCRYPT_POLICY_V2:
+		var v2 linux.FscryptPolicyV2
+		if _, err := v2.CopyIn(cc, addr); err != nil {
+			return err
+		}
+		if v2.Reserved != [4]uint8{} {
+			return syserror.EINVAL
+		}
+		p = encryptionPolicy{
+			version:       v2.Version,
+			contentsMode:  v2.ContentsEncryptionMode,
+			filenamesMode: v2.FilenamesEncryptionMode,
+			flags:         v2.Flags,
+			key:           v2.MasterKeyIdentifier,
+		}
+	default:
+		return syserror.EINVAL
+	}
+	if !p.valid() {
+		return syserror.EINVAL
+	}
+
+	creds := auth.CredentialsFromContext(ctx)
+	fs := fd.filesystem()
+	i := fd.inode()
+	if !vfs.CanActAsOwner(creds, auth.KUID(atomic.LoadUint32(&i.uid))) {
+		return syserror.EACCES
+	}
+	mnt := fd.vfsfd.Mount()
+	if err := mnt.CheckBeginWrite(); err != nil {
+		return err
+	}
+	defer mnt.EndWrite()
+
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	if i.encryption != nil {
+		if *i.encryption != p {
+			return syserror.EEXIST
+		}
+		return nil
+	}
+	dir, ok := i.impl.(*directory)
+	if !ok {
+		return syserror.ENOTDIR
+	}
+	if dir.dentry.vfsd.IsDead() {
+		return syserror.ENOENT
+	}
+	if len(dir.childMap) != 0 {
+		return syserror.ENOTEMPTY
+	}
+	if p.version == linux.FSCRYPT_POLICY_V2 {
+		// The caller must have added the key, so that users can't encrypt
+		// directories with keys that they don't know.
+		fs.keysMu.Lock()
+		key, ok := fs.keys[p.keySpec()]
+		if ok {
+			_, ok = key.users[creds.EffectiveKUID]
+		}
+		fs.keysMu.Unlock()
+		if !ok && !creds.HasCapability(linux.CAP_FOWNER) {
+			return syserror.ENOKEY
+		}
+	}
+	i.setEncryptionLocked(&p)
+	return nil
+}
+
+// getEncryptionPolicy implements FS_IOC_GET_ENCRYPTION_POLICY.
+func (fd *fileDescription) getEncryptionPolicy(ctx context.Context, uio usermem.IO, addr usermem.Addr) error {
+	fs := fd.filesystem()
+	fs.mu.RLock()
+	p := fd.inode().encryption
+	fs.mu.RUnlock()
+	if p == nil {
+		return syserror.ENODATA
+	}
+	if p.version != linux.FSCRYPT_POLICY_V1 {
+		return syserror.EINVAL
+	}
+	v1 := linux.FscryptPolicyV1{
+		Version:                 p.version,
+		ContentsEncryptionMode:  p.contentsMode,
+		FilenamesEncryptionMode: p.filenamesMode,
+		Flags:                   p.flags,
+	}
+	copy(v1.MasterKeyDescriptor[:], p.key[:])
+	_, err := v1.CopyOut(copyContext(ctx, uio), addr)
+	return err
+}
+
+// getEncryptionPolicyEx implements FS_IOC_GET_ENCRYPTION_POLICY_EX.
+func (fd *fileDescription) getEncryptionPolicyEx(ctx context.Context, uio usermem.IO, addr usermem.Addr) error {
+	// The argument is struct fscrypt_get_policy_ex_arg, consisting of a
+	// 64-bit size followed by the policy.
+	cc := copyContext(ctx, uio)
+	var size primitive.Uint64
+	if _, err := size.CopyIn(cc, addr); err != nil {
+		return err
+	}
+	fs := fd.filesystem()
+	fs.mu.RLock()
+	p := fd.inode().encryption
+	fs.mu.RUnlock()
+	if p == nil {
+		return syserror.ENODATA
+	}
+	var policy marshal.Marshallable
+	if p.version == linux.FSCRYPT_POLICY_V1 {
+		v1 := &linux.FscryptPolicyV1{
+			Version:                 p.version,
+			ContentsEncryptionMode:  p.contentsMode,
+			FilenamesEncryptionMode: p.filenamesMode,
+			Flags:                   p.flags,
+		}
+		copy(v1.MasterKeyDescriptor[:], p.key[:])
+		policy = v1
+	} else {
+		policy = &linux.FscryptPolicyV2{
+			Version:                 p.version,
+			ContentsEncryptionMode:  p.contentsMode,
+			FilenamesEncryptionMode: p.filenamesMode,
+			Flags:                   p.flags,
+			MasterKeyIdentifier:     p.key,
+		}
+	}
+	if uint64(size) < uint64(policy.SizeBytes()) {
+		return syserror.EOVERFLOW
+	}
+	size = primitive.Uint64(policy.SizeBytes())
+	if _, err := size.CopyOut(cc, addr); err != nil {
+		return err
+	}
+	_, err := policy.CopyOut(cc, addr+usermem.Addr(size.SizeBytes()))
+	return err
+}
+
+// getEncryptionNonce implements FS_IOC_GET_ENCRYPTION_NONCE.
+func (fd *fileDescription) getEncryptionNonce(ctx context.Context, uio usermem.IO, addr usermem.Addr) error {
+	fs := fd.filesystem()
+	i := fd.inode()
+	fs.mu.RLock()
+	p := i.encryption
+	nonce := i.encryptionNonce
+	fs.mu.RUnlock()
+	if p == nil {
+		return syserror.ENODATA
+	}
+	_, err := copyContext(ctx, uio).CopyOutBytes(addr, nonce[:])
+	return err
+}
+
+// keySpecFrom returns the master key specified by spec, which is used by a
+// key management ioctl that doesn't add a key.
+func keySpecFrom(creds *auth.Credentials, spec *linux.FscryptKeySpecifier, mayQuery bool) (encryptionKeySpec, error) {
+	if spec.Reserved != 0 {
+		return encryptionKeySpec{}, syserror.EINVAL
+	}
+	ks := encryptionKeySpec{typ: spec.Type}
+	switch spec.Type {
+	case linux.FSCRYPT_KEY_SPEC_TYPE_DESCRIPTOR:
+		// Keys specified by descriptor are not tracked per user, so only
+		// privileged users may remove them.
+		if !mayQuery && !creds.HasCapability(linux.CAP_SYS_ADMIN) {
+			return encryptionKeySpec{}, syserror.EACCES
+		}
+		copy(ks.id[:], spec.U[:linux.FSCRYPT_KEY_DESCRIPTOR_SIZE])
+	case linux.FSCRYPT_KEY_SPEC_TYPE_IDENTIFIER:
+		copy(ks.id[:], spec.U[:linux.FSCRYPT_KEY_IDENTIFIER_SIZE])
+	default:
+		return encryptionKeySpec{}, syserror.EINVAL
+	}
+	return ks, nil
+}
+
+// addEncryptionKey implements FS_IOC_ADD_ENCRYPTION_KEY.
+func (fd *fileDescription) addEncryptionKey(ctx context.Context, uio usermem.IO, addr usermem.Addr) error {
+	cc := copyContext(ctx, uio)
+	var arg linux.FscryptAddKeyArg
+	if _, err := arg.CopyIn(cc, addr); err != nil {
+		return err
+	}
+	if arg.KeySpec.Reserved != 0 || arg.Reserved != [8]uint32{} {
+		return syserror.EINVAL
+	}
+	if arg.KeyID != 0 {
+		// Keys can't be provided through keyrings, since keyctl(2) is not
+		// implemented.
+		return syserror.ENOKEY
+	}
+	if arg.RawSize < minEncryptionKeySize || arg.RawSize > linux.FSCRYPT_MAX_KEY_SIZE {
+		return syserror.EINVAL
+	}
+	raw := make([]byte, arg.RawSize)
+	if _, err := cc.CopyInBytes(addr+usermem.Addr(arg.SizeBytes()), raw); err != nil {
+		return err
+	}
+
+	creds := auth.CredentialsFromContext(ctx)
+	ks := encryptionKeySpec{typ: arg.KeySpec.Type}
+	switch arg.KeySpec.Type {
+	case linux.FSCRYPT_KEY_SPEC_TYPE_DESCRIPTOR:
+		if !creds.HasCapability(linux.CAP_SYS_ADMIN) {
+			return syserror.EACCES
+		}
+		copy(ks.id[:], arg.KeySpec.U[:linux.FSCRYPT_KEY_DESCRIPTOR_SIZE])
+	case linux.FSCRYPT_KEY_SPEC_TYPE_IDENTIFIER:
+		if arg.KeySpec.U != [32]byte{} {
+			return syserror.EINVAL
+		}
+		ks.id = keyIdentifier(raw)
+		copy(arg.KeySpec.U[:], ks.id[:])
+	default:
+		return syserror.EINVAL
+	}
+
+	fs := fd.filesystem()
+	fs.keysMu.Lock()
+	if fs.keys == nil {
+		fs.keys = make(map[encryptionKeySpec]*encryptionKey)
+	}
+	key, ok := fs.keys[ks]
+	if !ok {
+		key = &encryptionKey{users: make(map[auth.KUID]struct{})}
+		fs.keys[ks] = key
+	}
+	if ks.typ == linux.FSCRYPT_KEY_SPEC_TYPE_IDENTIFIER {
+		key.users[creds.EffectiveKUID] = struct{}{}
+	}
+	fs.keysMu.Unlock()
+
+	_, err := arg.CopyOut(cc, addr)
+	return err
+}
+
+// removeEncryptionKey implements FS_IOC_REMOVE_ENCRYPTION_KEY and, if
+// allUsers is true, FS_IOC_REMOVE_ENCRYPTION_KEY_ALL_USERS.
+func (fd *fileDescription) removeEncryptionKey(ctx context.Context, uio usermem.IO, addr usermem.Addr, allUsers bool) error {
+	cc := copyContext(ctx, uio)
+	var arg linux.FscryptRemoveKeyArg
+	if _, err := arg.CopyIn(cc, addr); err != nil {
+		return err
+	}
+	if arg.Reserved != [5]uint32{} {
+		return syserror.EINVAL
+	}
+	creds := auth.CredentialsFromContext(ctx)
+	if allUsers && !creds.HasCapability(linux.CAP_SYS_ADMIN) {
+		return syserror.EACCES
+	}
+	ks, err := keySpecFrom(creds, &arg.KeySpec, false /* mayQuery */)
+	if err != nil {
+		return err
+	}
+
+	fs := fd.filesystem()
+	fs.keysMu.Lock()
+	key, ok := fs.keys[ks]
+	if !ok {
+		fs.keysMu.Unlock()
+		return syserror.ENOKEY
+	}
+	arg.RemovalStatusFlags = 0
+	if !allUsers && ks.typ == linux.FSCRYPT_KEY_SPEC_TYPE_IDENTIFIER {
+		// Only remove the caller's claim to the key. The key itself is
+		// removed when no users have claims to it.
+		if _, ok := key.users[creds.EffectiveKUID]; !ok {
+			fs.keysMu.Unlock()
+			return syserror.ENOKEY
+		}
+		delete(key.users, creds.EffectiveKUID)
+		if len(key.users) != 0 {
+			arg.RemovalStatusFlags |= linux.FSCRYPT_KEY_REMOVAL_STATUS_FLAG_OTHER_USERS
+		}
+	}
+	if arg.RemovalStatusFlags == 0 {
+		delete(fs.keys, ks)
+	}
+	fs.keysMu.Unlock()
+
+	_, err = arg.CopyOut(cc, addr)
+	return err
+}
+
+// getEncryptionKeyStatus implements FS_IOC_GET_ENCRYPTION_KEY_STATUS.
+func (fd *fileDescription) getEncryptionKeyStatus(ctx context.Context, uio usermem.IO, addr usermem.Addr) error {
+	cc := copyContext(ctx, uio)
+	var arg linux.FscryptGetKeyStatusArg
+	if _, err := arg.CopyIn(cc, addr); err != nil {
+		return err
+	}
+	if arg.Reserved != [6]uint32{} {
+		return syserror.EINVAL
+	}
+	creds := auth.CredentialsFromContext(ctx)
+	ks, err := keySpecFrom(creds, &arg.KeySpec, true /* mayQuery */)
+	if err != nil {
+		return err
+	}
+
+	arg.Status = linux.FSCRYPT_KEY_STATUS_ABSENT
+	arg.StatusFlags = 0
+	arg.UserCount = 0
+	fs := fd.filesystem()
+	fs.keysMu.Lock()
+	if key, ok := fs.keys[ks]; ok {
+		arg.Status = linux.FSCRYPT_KEY_STATUS_PRESENT
+		if ks.typ == linux.FSCRYPT_KEY_SPEC_TYPE_IDENTIFIER {
+			arg.UserCount = uint32(len(key.users))
+			if _, ok := key.users[creds.EffectiveKUID]; ok {
+				arg.StatusFlags |= linux.FSCRYPT_KEY_STATUS_FLAG_ADDED_BY_SELF
+			}
+		}
+	}
+	fs.keysMu.Unlock()
+
+	_, err = arg.CopyOut(cc, addr)
+	return err
+}
@@ -0,0 +1,139 @@
+// Copyright 2020 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tmpfs
+
+import (
+	"bytes"
+	"testing"
+
+	"gvisor.dev/gvisor/pkg/abi/linux"
+	"gvisor.dev/gvisor/pkg/context"
+	"gvisor.dev/gvisor/pkg/fspath"
+	"gvisor.dev/gvisor/pkg/sentry/arch"
+	"gvisor.dev/gvisor/pkg/sentry/contexttest"
+	"gvisor.dev/gvisor/pkg/sentry/kernel/auth"
+	"gvisor.dev/gvisor/pkg/sentry/vfs"
+	"gvisor.dev/gvisor/pkg/syserror"
+	"gvisor.dev/gvisor/pkg/usermem"
+)
+
+// ioctl calls fd.Ioctl with an argument in buf.
+func ioctl(ctx context.Context, fd *vfs.FileDescription, cmd uint32, buf []byte) error {
+	_, err := fd.Ioctl(ctx, &usermem.BytesIO{Bytes: buf}, arch.SyscallArguments{
+		{},
+		{Value: uintptr(cmd)},
+		{Value: 0},
+	})
+	return err
+}
+
+func TestEncryptedDirectory(t *testing.T) {
+	ctx := contexttest.Context(t)
+	creds := auth.CredentialsFromContext(ctx)
+	vfsObj, root, cleanup, err := newTmpfsRoot(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cleanup()
+	pop := func(path string) *vfs.PathOperation {
+		return &vfs.PathOperation{Root: root, Start: root, Path: fspath.Parse(path)}
+	}
+
+	if err := vfsObj.MkdirAt(ctx, creds, pop("secret"), &vfs.MkdirOptions{Mode: 0755}); err != nil {
+		t.Fatalf("MkdirAt failed: %v", err)
+	}
+	dirFD, err := vfsObj.OpenAt(ctx, creds, pop("secret"), &vfs.OpenOptions{Flags: linux.O_RDONLY | linux.O_DIRECTORY})
+	if err != nil {
+		t.Fatalf("OpenAt failed: %v", err)
+	}
+	defer dirFD.DecRef(ctx)
+
+	// Add a key, and check that its identifier is returned.
+	raw := bytes.Repeat([]byte{0x42}, 32)
+	addArg := linux.FscryptAddKeyArg{
+		KeySpec: linux.FscryptKeySpecifier{Type: linux.FSCRYPT_KEY_SPEC_TYPE_IDENTIFIER},
+		RawSize: uint32(len(raw)),
+	}
+	buf := make([]byte, addArg.SizeBytes()+len(raw))
+	addArg.MarshalBytes(buf)
+	copy(buf[addArg.SizeBytes():], raw)
+	if err := ioctl(ctx, dirFD, linux.FS_IOC_ADD_ENCRYPTION_KEY, buf); err != nil {
+		t.Fatalf("FS_IOC_ADD_ENCRYPTION_KEY failed: %v", err)
+	}
+	addArg.UnmarshalBytes(buf)
+	id := keyIdentifier(raw)
+	if !bytes.Equal(addArg.KeySpec.U[:len(id)], id[:]) {
+		t.Errorf("FS_IOC_ADD_ENCRYPTION_KEY returned identifier %x, want %x", addArg.KeySpec.U[:len(id)], id)
+	}
+
+	// Encrypt the directory and create a file in it.
+	policy := linux.FscryptPolicyV2{
+		Version:                 linux.FSCRYPT_POLICY_V2,
+		ContentsEncryptionMode:  linux.FSCRYPT_MODE_AES_256_XTS,
+		FilenamesEncryptionMode: linux.FSCRYPT_MODE_AES_256_CTS,
+		MasterKeyIdentifier:     id,
+	}
+	policyBuf := make([]byte, policy.SizeBytes())
+	policy.MarshalBytes(policyBuf)
+	if err := ioctl(ctx, dirFD, linux.FS_IOC_SET_ENCRYPTION_POLICY, policyBuf); err != nil {
+		t.Fatalf("FS_IOC_SET_ENCRYPTION_POLICY failed: %v", err)
+	}
+	fd, err := vfsObj.OpenAt(ctx, creds, pop("secret/file"), &vfs.OpenOptions{
+		Flags: linux.O_RDWR | linux.O_CREAT | linux.O_EXCL,
+		Mode:  0644,
+	})
+	if err != nil {
+		t.Fatalf("OpenAt(O_CREAT) failed: %v", err)
+	}
+	stat, err := fd.Stat(ctx, vfs.StatOptions{})
+	fd.DecRef(ctx)
+	if err != nil {
+		t.Fatalf("Stat failed: %v", err)
+	}
+	if stat.Attributes&linux.STATX_ATTR_ENCRYPTED == 0 {
+		t.Errorf("file in encrypted directory has attributes %#x, want STATX_ATTR_ENCRYPTED", stat.Attributes)
+	}
+
+	// Remove the key. The file is now only accessible by its no-key name,
+	// and can't be opened; nothing can be created in the directory.
+	removeArg := linux.FscryptRemoveKeyArg{KeySpec: addArg.KeySpec}
+	removeBuf := make([]byte, removeArg.SizeBytes())
+	removeArg.MarshalBytes(removeBuf)
+	if err := ioctl(ctx, dirFD, linux.FS_IOC_REMOVE_ENCRYPTION_KEY, removeBuf); err != nil {
+		t.Fatalf("FS_IOC_REMOVE_ENCRYPTION_KEY failed: %v", err)
+	}
+	if _, err := vfsObj.OpenAt(ctx, creds, pop("secret/file"), &vfs.OpenOptions{Flags: linux.O_RDONLY}); err != syserror.ENOENT {
+		t.Errorf("OpenAt by name without key got error %v, want %v", err, syserror.ENOENT)
+	}
+	noKeyName := noKeyEncoding(&dirFD.Impl().(*directoryFD).inode().encryptionNonce, "file")
+	if _, err := vfsObj.OpenAt(ctx, creds, pop("secret/"+noKeyName), &vfs.OpenOptions{Flags: linux.O_RDONLY}); err != syserror.ENOKEY {
+		t.Errorf("OpenAt by no-key name got error %v, want %v", err, syserror.ENOKEY)
+	}
+	if err := vfsObj.MkdirAt(ctx, creds, pop("secret/dir"), &vfs.MkdirOptions{Mode: 0755}); err != syserror.ENOKEY {
+		t.Errorf("MkdirAt without key got error %v, want %v", err, syserror.ENOKEY)
+	}
+
+	// Adding the key back makes the file accessible again.
+	addArg.KeySpec.U = [32]byte{}
+	addArg.MarshalBytes(buf)
+	if err := ioctl(ctx, dirFD, linux.FS_IOC_ADD_ENCRYPTION_KEY, buf); err != nil {
+		t.Fatalf("FS_IOC_ADD_ENCRYPTION_KEY failed: %v", err)
+	}
+	fd, err = vfsObj.OpenAt(ctx, creds, pop("secret/file"), &vfs.OpenOptions{Flags: linux.O_RDONLY})
+	if err != nil {
+		t.Fatalf("OpenAt with key failed: %v", err)
+	}
+	fd.DecRef(ctx)
+}
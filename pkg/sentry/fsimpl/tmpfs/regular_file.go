@@ -77,6 +77,12 @@ type regularFile struct {
 	// Protected by dataMu.
 	data fsutil.FileRangeSet
 
+	// spilled is true if memFile is the kernel's spill MemoryFile rather than
+	// its MemoryFile. spilled can only change while data is empty.
+	//
+	// Protected by dataMu.
+	spilled bool
+
 	// seals represents file seals on this inode.
 	//
 	// Protected by dataMu.
@@ -218,7 +224,9 @@ func (rf *regularFile) truncateLocked(newSize uint64) (bool, error) {
 	// We are now guaranteed that there are no translations of truncated pages,
 	// and can remove them.
 	rf.dataMu.Lock()
+	span := rf.data.Span()
 	rf.data.Truncate(newSize, rf.memFile)
+	rf.unreservePagesLocked((span - rf.data.Span()) / usermem.PageSize)
 	rf.dataMu.Unlock()
 	return true, nil
 }
@@ -294,10 +302,27 @@ func (rf *regularFile) Translate(ctx context.Context, required, optional memmap.
 		optional.End = pgend
 	}
 
+	// Reserve capacity for the pages that Fill may allocate, which are limited
+	// to required if capacity is short.
+	rf.updateMemoryFileLocked()
+	toReserve := (optional.Length() - rf.data.SpanRange(optional)) / usermem.PageSize
+	reserved := rf.reservePagesLocked(toReserve)
+	if reserved < toReserve {
+		rf.unreservePagesLocked(reserved)
+		optional = required
+		toReserve = (required.Length() - rf.data.SpanRange(required)) / usermem.PageSize
+		reserved = rf.reservePagesLocked(toReserve)
+		if reserved < toReserve {
+			rf.unreservePagesLocked(reserved)
+			return nil, &memmap.BusError{syserror.ENOSPC}
+		}
+	}
+	span := rf.data.Span()
 	cerr := rf.data.Fill(ctx, required, optional, rf.size, rf.memFile, rf.memoryUsageKind, func(_ context.Context, dsts safemem.BlockSeq, _ uint64) (uint64, error) {
 		// Newly-allocated pages are zeroed, so we don't need to do anything.
 		return dsts.NumBytes(), nil
 	})
+	rf.unreservePagesLocked(reserved - (rf.data.Span()-span)/usermem.PageSize)
 
 	var ts []memmap.Translation
 	var translatedEnd uint64
@@ -646,10 +671,19 @@ func (rw *regularFileReadWriter) WriteFromBlocks(srcs safemem.BlockSeq) (uint64,
 			seg, gap = seg.NextNonEmpty()
 
 		case gap.Ok():
-			// Allocate memory for the write.
+			// Allocate memory for the write, as much as the filesystem has
+			// capacity for.
+			rw.file.updateMemoryFileLocked()
 			gapMR := gap.Range().Intersect(pgMR)
+			pages := rw.file.reservePagesLocked(gapMR.Length() / usermem.PageSize)
+			if pages == 0 {
+				retErr = syserror.ENOSPC
+				goto exitLoop
+			}
+			gapMR.End = gapMR.Start + pages*usermem.PageSize
 			fr, err := rw.file.memFile.Allocate(gapMR.Length(), rw.file.memoryUsageKind)
 			if err != nil {
+				rw.file.unreservePagesLocked(pages)
 				retErr = err
 				goto exitLoop
 			}
@@ -668,6 +702,72 @@ exitLoop:
 	return done, retErr
 }
 
+// memoryFile returns the MemoryFile that stores rf's data.
+//
+// Preconditions: rf.dataMu must be locked, or rf must not be in use.
+func (rf *regularFile) memoryFile() *pgalloc.MemoryFile {
+	if rf.spilled {
+		return rf.inode.fs.mfp.(pgalloc.SpillMemoryFileProvider).SpillMemoryFile()
+	}
+	return rf.inode.fs.mfp.MemoryFile()
+}
+
+// updateMemoryFileLocked chooses the MemoryFile that stores rf's data, if rf
+// has no data. rf's data is spilled if the filesystem stores at least its
+// spill threshold of data in memory when rf allocates its first page.
+//
+// Preconditions: rf.dataMu must be locked for writing.
+func (rf *regularFile) updateMemoryFileLocked() {
+	fs := rf.inode.fs
+	if !fs.spill || !rf.data.IsEmpty() {
+		return
+	}
+	inMemory := atomic.LoadUint64(&fs.pagesUsed) - atomic.LoadUint64(&fs.pagesSpilled)
+	if spilled := inMemory >= fs.spillThresholdPages; spilled != rf.spilled {
+		rf.spilled = spilled
+		rf.memFile = rf.memoryFile()
+	}
+}
+
+// reservePagesLocked reserves capacity in the filesystem for up to n pages of
+// rf's data, and returns the number of pages reserved.
+//
+// Preconditions: rf.dataMu must be locked for writing.
+func (rf *regularFile) reservePagesLocked(n uint64) uint64 {
+	fs := rf.inode.fs
+	for {
+		used := atomic.LoadUint64(&fs.pagesUsed)
+		reserved := n
+		if fs.maxSizeInPages != 0 {
+			if used >= fs.maxSizeInPages {
+				return 0
+			}
+			if avail := fs.maxSizeInPages - used; reserved > avail {
+				reserved = avail
+			}
+		}
+		if atomic.CompareAndSwapUint64(&fs.pagesUsed, used, used+reserved) {
+			if rf.spilled {
+				atomic.AddUint64(&fs.pagesSpilled, reserved)
+			}
+			return reserved
+		}
+	}
+}
+
+// unreservePagesLocked releases n pages of capacity reserved by
+// reservePagesLocked.
+//
+// Preconditions: rf.dataMu must be locked for writing, or rf must not be in
+// use.
+func (rf *regularFile) unreservePagesLocked(n uint64) {
+	fs := rf.inode.fs
+	atomic.AddUint64(&fs.pagesUsed, -n)
+	if rf.spilled {
+		atomic.AddUint64(&fs.pagesSpilled, -n)
+	}
+}
+
 // GetSeals returns the current set of seals on a memfd inode.
 func GetSeals(fd *vfs.FileDescription) (uint32, error) {
 	f, ok := fd.Impl().(*regularFileFD)
@@ -21,8 +21,10 @@ import (
 	"testing"
 
 	"gvisor.dev/gvisor/pkg/abi/linux"
+	"gvisor.dev/gvisor/pkg/fspath"
 	"gvisor.dev/gvisor/pkg/sentry/contexttest"
 	"gvisor.dev/gvisor/pkg/sentry/fs/lock"
+	"gvisor.dev/gvisor/pkg/sentry/kernel/auth"
 	"gvisor.dev/gvisor/pkg/sentry/vfs"
 	"gvisor.dev/gvisor/pkg/syserror"
 	"gvisor.dev/gvisor/pkg/usermem"
@@ -347,3 +349,70 @@ func TestTruncate(t *testing.T) {
 		t.Errorf("fd.Stat got Ctime %v, want %v", got, statAfterTruncateUp.Ctime)
 	}
 }
+
+func TestTmpfile(t *testing.T) {
+	ctx := contexttest.Context(t)
+	creds := auth.CredentialsFromContext(ctx)
+	vfsObj := &vfs.VirtualFilesystem{}
+	if err := vfsObj.Init(ctx); err != nil {
+		t.Fatalf("VFS init: %v", err)
+	}
+	vfsObj.MustRegisterFilesystemType("tmpfs", FilesystemType{}, &vfs.RegisterFilesystemTypeOptions{
+		AllowUserMount: true,
+	})
+	mntns, err := vfsObj.NewMountNamespace(ctx, creds, "", "tmpfs", &vfs.MountOptions{})
+	if err != nil {
+		t.Fatalf("failed to create tmpfs root mount: %v", err)
+	}
+	defer mntns.DecRef(ctx)
+	ctx = vfs.WithMountNamespace(ctx, mntns)
+	root := mntns.Root()
+	pop := func(path string) *vfs.PathOperation {
+		return &vfs.PathOperation{Root: root, Start: root, Path: fspath.Parse(path)}
+	}
+	tmpfile := func(flags uint32) *vfs.FileDescription {
+		fd, err := vfsObj.OpenAt(ctx, creds, pop("."), &vfs.OpenOptions{
+			Flags: linux.O_RDWR | linux.O_TMPFILE | linux.O_DIRECTORY | flags,
+			Mode:  0644,
+		})
+		if err != nil {
+			t.Fatalf("OpenAt(O_TMPFILE) failed: %v", err)
+		}
+		return fd
+	}
+	link := func(fd *vfs.FileDescription, newpath string) error {
+		return vfsObj.LinkAt(ctx, creds, &vfs.PathOperation{Root: root, Start: fd.VirtualDentry()}, pop(newpath))
+	}
+
+	// The file starts out with no links, and can be given one.
+	fd := tmpfile(0)
+	defer fd.DecRef(ctx)
+	stat, err := fd.Stat(ctx, vfs.StatOptions{})
+	if err != nil {
+		t.Fatalf("Stat failed: %v", err)
+	}
+	if stat.Nlink != 0 || stat.Mode != linux.S_IFREG|0644 {
+		t.Errorf("Stat got nlink %d, mode %#o; want 0, %#o", stat.Nlink, stat.Mode, linux.S_IFREG|0644)
+	}
+	data := "hello"
+	if _, err := fd.Write(ctx, usermem.BytesIOSequence([]byte(data)), vfs.WriteOptions{}); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+	if err := link(fd, "linked"); err != nil {
+		t.Fatalf("LinkAt failed: %v", err)
+	}
+	stat, err = vfsObj.StatAt(ctx, creds, pop("linked"), &vfs.StatOptions{})
+	if err != nil {
+		t.Fatalf("StatAt failed: %v", err)
+	}
+	if stat.Nlink != 1 || stat.Size != uint64(len(data)) {
+		t.Errorf("StatAt got nlink %d, size %d; want 1, %d", stat.Nlink, stat.Size, len(data))
+	}
+
+	// A file created with O_EXCL can't be linked.
+	exclFD := tmpfile(linux.O_EXCL)
+	defer exclFD.DecRef(ctx)
+	if err := link(exclFD, "excl"); err != syserror.ENOENT {
+		t.Errorf("LinkAt of O_TMPFILE|O_EXCL file got error %v, want %v", err, syserror.ENOENT)
+	}
+}
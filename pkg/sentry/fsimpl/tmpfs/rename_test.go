@@ -0,0 +1,86 @@
+// Copyright 2020 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tmpfs
+
+import (
+	"testing"
+
+	"gvisor.dev/gvisor/pkg/abi/linux"
+	"gvisor.dev/gvisor/pkg/fspath"
+	"gvisor.dev/gvisor/pkg/sentry/contexttest"
+	"gvisor.dev/gvisor/pkg/sentry/kernel/auth"
+	"gvisor.dev/gvisor/pkg/sentry/vfs"
+	"gvisor.dev/gvisor/pkg/syserror"
+)
+
+func TestRenameFlags(t *testing.T) {
+	ctx := contexttest.Context(t)
+	creds := auth.CredentialsFromContext(ctx)
+	vfsObj, root, cleanup, err := newTmpfsRoot(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cleanup()
+	pop := func(path string) *vfs.PathOperation {
+		return &vfs.PathOperation{Root: root, Start: root, Path: fspath.Parse(path)}
+	}
+	rename := func(oldpath, newpath string, flags uint32) error {
+		return vfsObj.RenameAt(ctx, creds, pop(oldpath), pop(newpath), &vfs.RenameOptions{Flags: flags})
+	}
+	fileType := func(path string) (uint16, error) {
+		stat, err := vfsObj.StatAt(ctx, creds, pop(path), &vfs.StatOptions{Mask: linux.STATX_TYPE})
+		return stat.Mode & linux.S_IFMT, err
+	}
+
+	if err := vfsObj.MkdirAt(ctx, creds, pop("dir"), &vfs.MkdirOptions{Mode: 0755}); err != nil {
+		t.Fatalf("MkdirAt failed: %v", err)
+	}
+	if err := vfsObj.MknodAt(ctx, creds, pop("file"), &vfs.MknodOptions{Mode: linux.S_IFREG | 0644}); err != nil {
+		t.Fatalf("MknodAt failed: %v", err)
+	}
+
+	if err := rename("file", "dir", linux.RENAME_NOREPLACE); err != syserror.EEXIST {
+		t.Errorf("RenameAt(RENAME_NOREPLACE) got error %v, want %v", err, syserror.EEXIST)
+	}
+	if err := rename("file", "missing", linux.RENAME_EXCHANGE); err != syserror.ENOENT {
+		t.Errorf("RenameAt(RENAME_EXCHANGE) to a missing file got error %v, want %v", err, syserror.ENOENT)
+	}
+
+	// Exchange a directory and a regular file.
+	if err := rename("file", "dir", linux.RENAME_EXCHANGE); err != nil {
+		t.Fatalf("RenameAt(RENAME_EXCHANGE) failed: %v", err)
+	}
+	if typ, err := fileType("file"); err != nil || typ != linux.S_IFDIR {
+		t.Errorf("after exchange, file has type %#o, err %v; want %#o", typ, err, linux.S_IFDIR)
+	}
+	if typ, err := fileType("dir"); err != nil || typ != linux.S_IFREG {
+		t.Errorf("after exchange, dir has type %#o, err %v; want %#o", typ, err, linux.S_IFREG)
+	}
+
+	// Leave a whiteout behind.
+	if err := rename("dir", "file/moved", linux.RENAME_WHITEOUT); err != nil {
+		t.Fatalf("RenameAt(RENAME_WHITEOUT) failed: %v", err)
+	}
+	if typ, err := fileType("file/moved"); err != nil || typ != linux.S_IFREG {
+		t.Errorf("renamed file has type %#o, err %v; want %#o", typ, err, linux.S_IFREG)
+	}
+	stat, err := vfsObj.StatAt(ctx, creds, pop("dir"), &vfs.StatOptions{Mask: linux.STATX_TYPE})
+	if err != nil {
+		t.Fatalf("StatAt of whiteout failed: %v", err)
+	}
+	if stat.Mode&linux.S_IFMT != linux.S_IFCHR || stat.RdevMajor != linux.WHITEOUT_DEV || stat.RdevMinor != linux.WHITEOUT_DEV {
+		t.Errorf("whiteout has mode %#o and device %d:%d, want a %d:%d character device", stat.Mode, stat.RdevMajor, stat.RdevMinor, linux.WHITEOUT_DEV, linux.WHITEOUT_DEV)
+	}
+}
@@ -16,5 +16,5 @@ package tmpfs
 
 // afterLoad is called by stateify.
 func (rf *regularFile) afterLoad() {
-	rf.memFile = rf.inode.fs.mfp.MemoryFile()
+	rf.memFile = rf.memoryFile()
 }
@@ -0,0 +1,190 @@
+// Copyright 2021 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tmpfs
+
+import (
+	"testing"
+
+	"gvisor.dev/gvisor/pkg/abi/linux"
+	"gvisor.dev/gvisor/pkg/context"
+	"gvisor.dev/gvisor/pkg/fspath"
+	"gvisor.dev/gvisor/pkg/sentry/contexttest"
+	"gvisor.dev/gvisor/pkg/sentry/kernel/auth"
+	"gvisor.dev/gvisor/pkg/sentry/vfs"
+	"gvisor.dev/gvisor/pkg/syserror"
+	"gvisor.dev/gvisor/pkg/usermem"
+)
+
+// newLimitedTmpfsRoot is like newTmpfsRoot, but mounts tmpfs with the given
+// mount options.
+func newLimitedTmpfsRoot(ctx context.Context, t *testing.T, data string) (*vfs.VirtualFilesystem, vfs.VirtualDentry, func()) {
+	creds := auth.CredentialsFromContext(ctx)
+	vfsObj := &vfs.VirtualFilesystem{}
+	if err := vfsObj.Init(ctx); err != nil {
+		t.Fatalf("VFS init: %v", err)
+	}
+	vfsObj.MustRegisterFilesystemType("tmpfs", FilesystemType{}, &vfs.RegisterFilesystemTypeOptions{
+		AllowUserMount: true,
+	})
+	mntns, err := vfsObj.NewMountNamespace(ctx, creds, "", "tmpfs", &vfs.MountOptions{
+		GetFilesystemOptions: vfs.GetFilesystemOptions{
+			Data: data,
+		},
+	})
+	if err != nil {
+		t.Fatalf("failed to create tmpfs root mount: %v", err)
+	}
+	root := mntns.Root()
+	root.IncRef()
+	return vfsObj, root, func() {
+		root.DecRef(ctx)
+		mntns.DecRef(ctx)
+	}
+}
+
+func TestSizeLimit(t *testing.T) {
+	ctx := contexttest.Context(t)
+	creds := auth.CredentialsFromContext(ctx)
+	vfsObj, root, cleanup := newLimitedTmpfsRoot(ctx, t, "size=8k")
+	defer cleanup()
+
+	pop := &vfs.PathOperation{
+		Root:  root,
+		Start: root,
+		Path:  fspath.Parse("file"),
+	}
+	fd, err := vfsObj.OpenAt(ctx, creds, pop, &vfs.OpenOptions{
+		Flags: linux.O_RDWR | linux.O_CREAT | linux.O_EXCL,
+		Mode:  0644,
+	})
+	if err != nil {
+		t.Fatalf("failed to create file: %v", err)
+	}
+	defer fd.DecRef(ctx)
+
+	statfs := func() linux.Statfs {
+		stat, err := vfsObj.StatFSAt(ctx, creds, pop)
+		if err != nil {
+			t.Fatalf("StatFSAt failed: %v", err)
+		}
+		return stat
+	}
+	if got := statfs(); got.Blocks != 2 || got.BlocksFree != 2 || got.BlocksAvailable != 2 {
+		t.Errorf("empty filesystem has %d blocks, %d free, %d available; want 2, 2, 2", got.Blocks, got.BlocksFree, got.BlocksAvailable)
+	}
+
+	// Writes beyond the filesystem's capacity are partial, and then fail.
+	data := make([]byte, 3*usermem.PageSize)
+	n, err := fd.PWrite(ctx, usermem.BytesIOSequence(data), 0, vfs.WriteOptions{})
+	if n != 2*usermem.PageSize || err != syserror.ENOSPC {
+		t.Errorf("fd.PWrite got (%d, %v), want (%d, %v)", n, err, 2*usermem.PageSize, syserror.ENOSPC)
+	}
+	if got := statfs(); got.BlocksFree != 0 {
+		t.Errorf("full filesystem has %d free blocks, want 0", got.BlocksFree)
+	}
+
+	// Truncation releases capacity.
+	if err := vfsObj.SetStatAt(ctx, creds, pop, &vfs.SetStatOptions{
+		Stat: linux.Statx{
+			Mask: linux.STATX_SIZE,
+			Size: usermem.PageSize,
+		},
+	}); err != nil {
+		t.Fatalf("failed to truncate file: %v", err)
+	}
+	if got := statfs(); got.BlocksFree != 1 {
+		t.Errorf("filesystem has %d free blocks after truncation, want 1", got.BlocksFree)
+	}
+	if n, err := fd.PWrite(ctx, usermem.BytesIOSequence(data[:usermem.PageSize]), usermem.PageSize, vfs.WriteOptions{}); n != usermem.PageSize || err != nil {
+		t.Errorf("fd.PWrite after truncation got (%d, %v), want (%d, nil)", n, err, usermem.PageSize)
+	}
+}
+
+func TestInodeLimit(t *testing.T) {
+	ctx := contexttest.Context(t)
+	creds := auth.CredentialsFromContext(ctx)
+	vfsObj, root, cleanup := newLimitedTmpfsRoot(ctx, t, "nr_inodes=3")
+	defer cleanup()
+
+	mkdir := func(name string) error {
+		return vfsObj.MkdirAt(ctx, creds, &vfs.PathOperation{
+			Root:  root,
+			Start: root,
+			Path:  fspath.Parse(name),
+		}, &vfs.MkdirOptions{Mode: 0755})
+	}
+	// The root directory is the first inode.
+	for _, name := range []string{"a", "b"} {
+		if err := mkdir(name); err != nil {
+			t.Fatalf("mkdir %q failed: %v", name, err)
+		}
+	}
+	if err := mkdir("c"); err != syserror.ENOSPC {
+		t.Errorf("mkdir beyond inode limit got %v, want %v", err, syserror.ENOSPC)
+	}
+
+	stat, err := vfsObj.StatFSAt(ctx, creds, &vfs.PathOperation{
+		Root:  root,
+		Start: root,
+	})
+	if err != nil {
+		t.Fatalf("StatFSAt failed: %v", err)
+	}
+	if stat.Files != 3 || stat.FilesFree != 0 {
+		t.Errorf("filesystem has %d inodes, %d free; want 3, 0", stat.Files, stat.FilesFree)
+	}
+
+	if err := vfsObj.RmdirAt(ctx, creds, &vfs.PathOperation{
+		Root:  root,
+		Start: root,
+		Path:  fspath.Parse("a"),
+	}); err != nil {
+		t.Fatalf("rmdir failed: %v", err)
+	}
+	if err := mkdir("c"); err != nil {
+		t.Errorf("mkdir after rmdir failed: %v", err)
+	}
+}
+
+func TestParseSize(t *testing.T) {
+	for _, test := range []struct {
+		str  string
+		want uint64
+		err  bool
+	}{
+		{str: "0", want: 0},
+		{str: "4096", want: 4096},
+		{str: "4k", want: 4 << 10},
+		{str: "12M", want: 12 << 20},
+		{str: "1g", want: 1 << 30},
+		{str: "2t", want: 2 << 40},
+		{str: "16e", err: true},
+		{str: "", err: true},
+		{str: "k", err: true},
+		{str: "-1", err: true},
+		{str: "50%", err: true},
+	} {
+		got, err := parseSize(nil, test.str)
+		if test.err {
+			if err == nil {
+				t.Errorf("parseSize(%q) got %d, want error", test.str, got)
+			}
+			continue
+		}
+		if err != nil || got != test.want {
+			t.Errorf("parseSize(%q) got (%d, %v), want (%d, nil)", test.str, got, err, test.want)
+		}
+	}
+}
@@ -25,6 +25,7 @@
 //         *** "memmap.Mappable locks taken by Translate" below this point
 //         regularFile.dataMu
 //     directory.iterMu
+//   filesystem.keysMu
 package tmpfs
 
 import (
@@ -36,9 +37,11 @@ import (
 
 	"gvisor.dev/gvisor/pkg/abi/linux"
 	"gvisor.dev/gvisor/pkg/context"
+	"gvisor.dev/gvisor/pkg/sentry/arch"
 	"gvisor.dev/gvisor/pkg/sentry/kernel/auth"
 	"gvisor.dev/gvisor/pkg/sentry/kernel/time"
 	"gvisor.dev/gvisor/pkg/sentry/pgalloc"
+	"gvisor.dev/gvisor/pkg/sentry/usage"
 	"gvisor.dev/gvisor/pkg/sentry/vfs"
 	"gvisor.dev/gvisor/pkg/sentry/vfs/memxattr"
 	"gvisor.dev/gvisor/pkg/sync"
@@ -76,6 +79,38 @@ type filesystem struct {
 	nextInoMinusOne uint64 // accessed using atomic memory operations
 
 	root *dentry
+
+	// maxSizeInPages is the maximum number of pages of regular file data that
+	// the filesystem may store, or 0 if this is unlimited. maxSizeInPages is
+	// immutable.
+	maxSizeInPages uint64
+
+	// maxInodes is the maximum number of inodes in the filesystem, or 0 if
+	// this is unlimited. maxInodes is immutable.
+	maxInodes uint64
+
+	// If spill is true, regular files that first allocate data while the
+	// filesystem stores at least spillThresholdPages pages of data in memory
+	// store their data in the kernel's spill MemoryFile instead, which is
+	// backed by host storage. spill and spillThresholdPages are immutable.
+	spill               bool
+	spillThresholdPages uint64
+
+	// pagesUsed is the number of pages of regular file data stored by the
+	// filesystem, and pagesSpilled is the number of those pages stored in the
+	// spill MemoryFile. pagesUsed and pagesSpilled are accessed using atomic
+	// memory operations.
+	pagesUsed    uint64
+	pagesSpilled uint64
+
+	// inodesUsed is the number of inodes in the filesystem. inodesUsed is
+	// accessed using atomic memory operations.
+	inodesUsed uint64
+
+	// keys maps the encryption master keys that have been added to the
+	// filesystem to their state. See fscrypt.go. keys is protected by keysMu.
+	keysMu sync.Mutex `state:"nosave"`
+	keys   map[encryptionKeySpec]*encryptionKey
 }
 
 // Name implements vfs.FilesystemType.Name.
@@ -170,6 +205,47 @@ func (fstype FilesystemType) GetFilesystem(ctx context.Context, vfsObj *vfs.Virt
 		}
 		rootKGID = kgid
 	}
+	var maxSizeInPages uint64
+	sizeStr, ok := mopts["size"]
+	if ok {
+		delete(mopts, "size")
+		size, err := parseSize(mfp, sizeStr)
+		if err != nil {
+			ctx.Warningf("tmpfs.FilesystemType.GetFilesystem: invalid size: %q", sizeStr)
+			return nil, nil, syserror.EINVAL
+		}
+		maxSizeInPages = pagesForSize(size)
+	}
+	var maxInodes uint64
+	nrInodesStr, ok := mopts["nr_inodes"]
+	if ok {
+		delete(mopts, "nr_inodes")
+		nrInodes, err := parseSize(nil, nrInodesStr)
+		if err != nil {
+			ctx.Warningf("tmpfs.FilesystemType.GetFilesystem: invalid nr_inodes: %q", nrInodesStr)
+			return nil, nil, syserror.EINVAL
+		}
+		maxInodes = nrInodes
+	}
+	var (
+		spill               bool
+		spillThresholdPages uint64
+	)
+	spillStr, ok := mopts["spill_threshold"]
+	if ok {
+		delete(mopts, "spill_threshold")
+		threshold, err := parseSize(mfp, spillStr)
+		if err != nil {
+			ctx.Warningf("tmpfs.FilesystemType.GetFilesystem: invalid spill_threshold: %q", spillStr)
+			return nil, nil, syserror.EINVAL
+		}
+		if smfp, ok := mfp.(pgalloc.SpillMemoryFileProvider); !ok || smfp.SpillMemoryFile() == nil {
+			ctx.Warningf("tmpfs.FilesystemType.GetFilesystem: spill_threshold requires a spill file")
+			return nil, nil, syserror.EINVAL
+		}
+		spill = true
+		spillThresholdPages = pagesForSize(threshold)
+	}
 	if len(mopts) != 0 {
 		ctx.Warningf("tmpfs.FilesystemType.GetFilesystem: unknown options: %v", mopts)
 		return nil, nil, syserror.EINVAL
@@ -181,9 +257,13 @@ func (fstype FilesystemType) GetFilesystem(ctx context.Context, vfsObj *vfs.Virt
 	}
 	clock := time.RealtimeClockFromContext(ctx)
 	fs := filesystem{
-		mfp:      mfp,
-		clock:    clock,
-		devMinor: devMinor,
+		mfp:                 mfp,
+		clock:               clock,
+		devMinor:            devMinor,
+		maxSizeInPages:      maxSizeInPages,
+		maxInodes:           maxInodes,
+		spill:               spill,
+		spillThresholdPages: spillThresholdPages,
 	}
 	fs.vfsfs.Init(vfsObj, newFSType, &fs)
 
@@ -244,23 +324,101 @@ func (d *dentry) releaseChildrenLocked(ctx context.Context) {
 	}
 }
 
-// immutable
-var globalStatfs = linux.Statfs{
-	Type:         linux.TMPFS_MAGIC,
-	BlockSize:    usermem.PageSize,
-	FragmentSize: usermem.PageSize,
-	NameLength:   linux.NAME_MAX,
+// parseSize parses the value of a size mount option, which is a number with
+// an optional k, m, g, t, p or e suffix. If mfp is not nil, the value may
+// also be a percentage of total memory, with a % suffix.
+func parseSize(mfp pgalloc.MemoryFileProvider, str string) (uint64, error) {
+	if str == "" {
+		return 0, fmt.Errorf("empty size")
+	}
+	if mfp != nil && strings.HasSuffix(str, "%") {
+		percent, err := strconv.ParseUint(str[:len(str)-1], 10, 64)
+		if err != nil {
+			return 0, err
+		}
+		mf := mfp.MemoryFile()
+		used, err := mf.TotalUsage()
+		if err != nil {
+			return 0, err
+		}
+		return usage.TotalMemory(mf.TotalSize(), used) / 100 * percent, nil
+	}
+	shift := uint(0)
+	switch str[len(str)-1] {
+	case 'k', 'K':
+		shift = 10
+	case 'm', 'M':
+		shift = 20
+	case 'g', 'G':
+		shift = 30
+	case 't', 'T':
+		shift = 40
+	case 'p', 'P':
+		shift = 50
+	case 'e', 'E':
+		shift = 60
+	}
+	if shift != 0 {
+		str = str[:len(str)-1]
+	}
+	n, err := strconv.ParseUint(str, 10, 64)
+	if err != nil {
+		return 0, err
+	}
+	if n > math.MaxUint64>>shift {
+		return 0, fmt.Errorf("size overflows")
+	}
+	return n << shift, nil
+}
 
-	// tmpfs currently does not support configurable size limits. In Linux,
-	// such a tmpfs mount will return f_blocks == f_bfree == f_bavail == 0 from
-	// statfs(2). However, many applications treat this as having a size limit
-	// of 0. To work around this, claim to have a very large but non-zero size,
-	// chosen to ensure that BlockSize * Blocks does not overflow int64 (which
-	// applications may also handle incorrectly).
-	// TODO(b/29637826): allow configuring a tmpfs size and enforce it.
-	Blocks:          math.MaxInt64 / usermem.PageSize,
-	BlocksFree:      math.MaxInt64 / usermem.PageSize,
-	BlocksAvailable: math.MaxInt64 / usermem.PageSize,
+// pagesForSize returns the number of pages needed to store size bytes.
+func pagesForSize(size uint64) uint64 {
+	return size/usermem.PageSize + (size%usermem.PageSize+usermem.PageSize-1)/usermem.PageSize
+}
+
+// statFS returns the filesystem's usage and limits, as reported by statfs(2).
+func (fs *filesystem) statFS() linux.Statfs {
+	stat := linux.Statfs{
+		Type:         linux.TMPFS_MAGIC,
+		BlockSize:    usermem.PageSize,
+		FragmentSize: usermem.PageSize,
+		NameLength:   linux.NAME_MAX,
+	}
+
+	if fs.maxSizeInPages == 0 {
+		// In Linux, a tmpfs mount without a size limit returns f_blocks ==
+		// f_bfree == f_bavail == 0 from statfs(2). However, many applications
+		// treat this as having a size limit of 0. To work around this, claim
+		// to have a very large but non-zero size, chosen to ensure that
+		// BlockSize * Blocks does not overflow int64 (which applications may
+		// also handle incorrectly).
+		stat.Blocks = math.MaxInt64 / usermem.PageSize
+	} else {
+		stat.Blocks = fs.maxSizeInPages
+	}
+	if used := atomic.LoadUint64(&fs.pagesUsed); used < stat.Blocks {
+		stat.BlocksFree = stat.Blocks - used
+		stat.BlocksAvailable = stat.BlocksFree
+	}
+
+	// As in Linux, f_files == f_ffree == 0 if the number of inodes is
+	// unlimited.
+	if fs.maxInodes != 0 {
+		stat.Files = fs.maxInodes
+		if used := atomic.LoadUint64(&fs.inodesUsed); used < stat.Files {
+			stat.FilesFree = stat.Files - used
+		}
+	}
+	return stat
+}
+
+// checkInodeAvailable returns ENOSPC if the filesystem can't have another
+// inode.
+func (fs *filesystem) checkInodeAvailable() error {
+	if fs.maxInodes != 0 && atomic.LoadUint64(&fs.inodesUsed) >= fs.maxInodes {
+		return syserror.ENOSPC
+	}
+	return nil
 }
 
 // dentry implements vfs.DentryImpl.
@@ -380,6 +538,21 @@ type inode struct {
 	// Inotify watches for this inode.
 	watches vfs.Watches
 
+	// encryption is the inode's encryption policy, or nil if it is not
+	// encrypted, and encryptionNonce is its unique nonce. encryption and
+	// encryptionNonce are protected by filesystem.mu, and are immutable once
+	// encryption is set. encrypted is 1 if encryption is set; it is accessed
+	// using atomic memory operations to avoid locking in inode.statTo().
+	encryption      *encryptionPolicy
+	encryptionNonce [linux.FSCRYPT_FILE_NONCE_SIZE]byte
+	encrypted       uint32
+
+	// linkable is true if the inode was created by open(O_TMPFILE) without
+	// O_EXCL and has not yet been linked into the filesystem tree, in which
+	// case LinkAt may give it a link despite nlink being 0. linkable is
+	// protected by filesystem.mu.
+	linkable bool
+
 	impl interface{} // immutable
 }
 
@@ -394,6 +567,7 @@ func (i *inode) init(impl interface{}, fs *filesystem, kuid auth.KUID, kgid auth
 	i.uid = uint32(kuid)
 	i.gid = uint32(kgid)
 	i.ino = atomic.AddUint64(&fs.nextInoMinusOne, 1)
+	atomic.AddUint64(&fs.inodesUsed, 1)
 	// Tmpfs creation sets atime, ctime, and mtime to current time.
 	now := fs.clock.Now().Nanoseconds()
 	i.atime = now
@@ -450,8 +624,10 @@ func (i *inode) decRef(ctx context.Context) {
 			// Release memory used by regFile to store data. Since regFile is
 			// no longer usable, we don't need to grab any locks or update any
 			// metadata.
+			regFile.unreservePagesLocked(regFile.data.Span() / usermem.PageSize)
 			regFile.data.DropAll(regFile.memFile)
 		}
+		atomic.AddUint64(&i.fs.inodesUsed, ^uint64(0))
 	})
 }
 
@@ -482,6 +658,10 @@ func (i *inode) statTo(stat *linux.Statx) {
 	stat.Mtime = linux.NsecToStatxTimestamp(atomic.LoadInt64(&i.mtime))
 	stat.DevMajor = linux.UNNAMED_MAJOR
 	stat.DevMinor = i.fs.devMinor
+	stat.AttributesMask = linux.STATX_ATTR_ENCRYPTED
+	if atomic.LoadUint32(&i.encrypted) != 0 {
+		stat.Attributes |= linux.STATX_ATTR_ENCRYPTED
+	}
 	switch impl := i.impl.(type) {
 	case *regularFile:
 		stat.Mask |= linux.STATX_SIZE | linux.STATX_BLOCKS
@@ -542,6 +722,9 @@ func (i *inode) setStat(ctx context.Context, creds *auth.Credentials, opts *vfs.
 	if mask&linux.STATX_SIZE != 0 {
 		switch impl := i.impl.(type) {
 		case *regularFile:
+			if !i.fs.hasKey(i.encryption) {
+				return syserror.ENOKEY
+			}
 			updated, err := impl.truncateLocked(stat.Size)
 			if err != nil {
 				return err
@@ -759,7 +942,7 @@ func (fd *fileDescription) SetStat(ctx context.Context, opts vfs.SetStatOptions)
 
 // StatFS implements vfs.FileDescriptionImpl.StatFS.
 func (fd *fileDescription) StatFS(ctx context.Context) (linux.Statfs, error) {
-	return globalStatfs, nil
+	return fd.filesystem().statFS(), nil
 }
 
 // ListXattr implements vfs.FileDescriptionImpl.ListXattr.
@@ -796,6 +979,31 @@ func (fd *fileDescription) RemoveXattr(ctx context.Context, name string) error {
 	return nil
 }
 
+// Ioctl implements vfs.FileDescriptionImpl.Ioctl.
+func (fd *fileDescription) Ioctl(ctx context.Context, uio usermem.IO, args arch.SyscallArguments) (uintptr, error) {
+	addr := args[2].Pointer()
+	switch args[1].Uint() {
+	case linux.FS_IOC_SET_ENCRYPTION_POLICY:
+		return 0, fd.setEncryptionPolicy(ctx, uio, addr)
+	case linux.FS_IOC_GET_ENCRYPTION_POLICY:
+		return 0, fd.getEncryptionPolicy(ctx, uio, addr)
+	case linux.FS_IOC_GET_ENCRYPTION_POLICY_EX:
+		return 0, fd.getEncryptionPolicyEx(ctx, uio, addr)
+	case linux.FS_IOC_GET_ENCRYPTION_NONCE:
+		return 0, fd.getEncryptionNonce(ctx, uio, addr)
+	case linux.FS_IOC_ADD_ENCRYPTION_KEY:
+		return 0, fd.addEncryptionKey(ctx, uio, addr)
+	case linux.FS_IOC_REMOVE_ENCRYPTION_KEY:
+		return 0, fd.removeEncryptionKey(ctx, uio, addr, false /* allUsers */)
+	case linux.FS_IOC_REMOVE_ENCRYPTION_KEY_ALL_USERS:
+		return 0, fd.removeEncryptionKey(ctx, uio, addr, true /* allUsers */)
+	case linux.FS_IOC_GET_ENCRYPTION_KEY_STATUS:
+		return 0, fd.getEncryptionKeyStatus(ctx, uio, addr)
+	default:
+		return fd.FileDescriptionDefaultImpl.Ioctl(ctx, uio, args)
+	}
+}
+
 // Sync implements vfs.FileDescriptionImpl.Sync. It does nothing because all
 // filesystem state is in-memory.
 func (*fileDescription) Sync(context.Context) error {
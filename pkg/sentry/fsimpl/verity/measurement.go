@@ -0,0 +1,90 @@
+// Copyright 2021 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package verity
+
+import (
+	"gvisor.dev/gvisor/pkg/context"
+	ktime "gvisor.dev/gvisor/pkg/sentry/kernel/time"
+	"gvisor.dev/gvisor/pkg/sync"
+)
+
+// maxMeasurements is the maximum number of entries kept in the measurement
+// log. Once it's reached, the oldest entries are dropped.
+const maxMeasurements = 4096
+
+// Measurement is an entry of the measurement log, which records the hashes
+// files were verified against, as well as detected integrity violations.
+type Measurement struct {
+	// Time is the time of the measurement, in nanoseconds since the Unix
+	// epoch.
+	Time int64
+
+	// Path is the path of the measured file, relative to the root of the
+	// verity file system. It's empty for violations.
+	Path string
+
+	// Alg is the name of the hash algorithm of Hash.
+	Alg string
+
+	// Hash is the hex encoded hash the file was verified against.
+	Hash string
+
+	// Signed is true if Hash is a root hash whose signature was verified.
+	Signed bool
+
+	// Violation describes the detected integrity violation, if any.
+	Violation string
+}
+
+// measurements is the measurement log, shared by all verity file systems.
+var measurements struct {
+	mu sync.Mutex
+
+	// entries is a ring buffer of the logged measurements. next is the
+	// index in entries of the next measurement to be logged.
+	entries []Measurement
+	next    int
+
+	// dropped is the number of measurements dropped from entries.
+	dropped uint64
+}
+
+// recordMeasurement adds m to the measurement log.
+func recordMeasurement(ctx context.Context, m Measurement) {
+	if clock := ktime.RealtimeClockFromContext(ctx); clock != nil {
+		m.Time = clock.Now().Nanoseconds()
+	}
+
+	measurements.mu.Lock()
+	defer measurements.mu.Unlock()
+	if len(measurements.entries) < maxMeasurements {
+		measurements.entries = append(measurements.entries, m)
+		return
+	}
+	measurements.entries[measurements.next] = m
+	measurements.next = (measurements.next + 1) % maxMeasurements
+	measurements.dropped++
+}
+
+// Measurements returns the entries of the measurement log, oldest first, and
+// the number of entries that were dropped from it.
+func Measurements() ([]Measurement, uint64) {
+	measurements.mu.Lock()
+	defer measurements.mu.Unlock()
+	ms := make([]Measurement, 0, len(measurements.entries))
+	ms = append(ms, measurements.entries[measurements.next:]...)
+	ms = append(ms, measurements.entries[:measurements.next]...)
+	return ms, measurements.dropped
+}
@@ -0,0 +1,93 @@
+// Copyright 2021 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package verity
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// formattedDigestMagic is the magic of struct fsverity_formatted_digest.
+const formattedDigestMagic = "FSVerity"
+
+// formattedDigest returns hash formatted as struct fsverity_formatted_digest,
+// which is the message signed by fs-verity builtin signatures.
+func formattedDigest(alg HashAlgorithm, hash []byte) []byte {
+	b := make([]byte, len(formattedDigestMagic)+4+len(hash))
+	copy(b, formattedDigestMagic)
+	binary.LittleEndian.PutUint16(b[8:], uint16(alg.toLinuxHashAlg()))
+	binary.LittleEndian.PutUint16(b[10:], uint16(len(hash)))
+	copy(b[12:], hash)
+	return b
+}
+
+// signatureAlgorithm returns the signature algorithm of signatures made with
+// the private key of pub over digests hashed with alg.
+func signatureAlgorithm(pub interface{}, alg HashAlgorithm) (x509.SignatureAlgorithm, error) {
+	switch pub.(type) {
+	case *rsa.PublicKey:
+		if alg == SHA512 {
+			return x509.SHA512WithRSA, nil
+		}
+		return x509.SHA256WithRSA, nil
+	case *ecdsa.PublicKey:
+		if alg == SHA512 {
+			return x509.ECDSAWithSHA512, nil
+		}
+		return x509.ECDSAWithSHA256, nil
+	case ed25519.PublicKey:
+		return x509.PureEd25519, nil
+	default:
+		return x509.UnknownSignatureAlgorithm, fmt.Errorf("unsupported public key type %T", pub)
+	}
+}
+
+// verifyRootHashSignature verifies that sig is a signature of rootHash made
+// with the private key of one of certs, which are DER encoded X.509
+// certificates.
+//
+// As with fs-verity builtin signatures, the signed message is rootHash
+// formatted as struct fsverity_formatted_digest. Unlike them, sig is a raw
+// signature rather than a PKCS#7 message: PKCS#1 v1.5 for RSA keys, ASN.1
+// DER for ECDSA keys, and as in RFC 8032 for Ed25519 keys. Except for
+// Ed25519, the message is hashed with alg.
+func verifyRootHashSignature(alg HashAlgorithm, rootHash, sig []byte, certs [][]byte) error {
+	if len(rootHash) == 0 {
+		return errors.New("no root hash")
+	}
+	if len(sig) == 0 {
+		return errors.New("no signature")
+	}
+	msg := formattedDigest(alg, rootHash)
+	for _, der := range certs {
+		cert, err := x509.ParseCertificate(der)
+		if err != nil {
+			return fmt.Errorf("failed to parse trusted certificate: %v", err)
+		}
+		sigAlg, err := signatureAlgorithm(cert.PublicKey, alg)
+		if err != nil {
+			return err
+		}
+		if err := cert.CheckSignature(sigAlg, msg, sig); err == nil {
+			return nil
+		}
+	}
+	return errors.New("signature doesn't match any trusted certificate")
+}
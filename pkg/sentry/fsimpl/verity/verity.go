@@ -34,6 +34,7 @@
 package verity
 
 import (
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"math"
@@ -114,6 +115,15 @@ const (
 	// ErrorOnViolation returns an error from the violating system call on
 	// detected violation.
 	ErrorOnViolation = 1
+	// LogOnViolation logs detected violations, and lets the violating system
+	// call proceed with unverified data if the data is otherwise available,
+	// e.g. if a hash mismatches. Violations that leave nothing to proceed
+	// with, e.g. a missing Merkle tree file, return an error as in
+	// ErrorOnViolation.
+	LogOnViolation = 2
+	// KillOnViolation kills the thread group of the task that detected the
+	// violation, and returns an error from the violating system call.
+	KillOnViolation = 3
 )
 
 // Currently supported hashing algorithms include SHA256 and SHA512.
@@ -122,6 +132,17 @@ const (
 	SHA512
 )
 
+func (alg HashAlgorithm) name() string {
+	switch alg {
+	case SHA256:
+		return "sha256"
+	case SHA512:
+		return "sha512"
+	default:
+		return "unknown"
+	}
+}
+
 func (alg HashAlgorithm) toLinuxHashAlg() int {
 	switch alg {
 	case SHA256:
@@ -201,6 +222,14 @@ type InternalFilesystemOptions struct {
 	// RootHash is the root hash of the overall verity file system.
 	RootHash []byte
 
+	// RootHashSignature is the signature of RootHash. See
+	// verifyRootHashSignature for its format.
+	RootHashSignature []byte
+
+	// TrustedCertificates are DER encoded X.509 certificates. If not
+	// empty, RootHashSignature must be made by the key of one of them.
+	TrustedCertificates [][]byte
+
 	// AllowRuntimeEnable specifies whether the verity file system allows
 	// enabling verification for files (i.e. building Merkle trees) during
 	// runtime.
@@ -223,13 +252,36 @@ func (FilesystemType) Name() string {
 func (FilesystemType) Release(ctx context.Context) {}
 
 // alertIntegrityViolation alerts a violation of integrity, which usually means
-// unexpected modification to the file system is detected. In ErrorOnViolation
-// mode, it returns EIO, otherwise it panic.
-func alertIntegrityViolation(msg string) error {
-	if action == ErrorOnViolation {
-		return syserror.EIO
+// unexpected modification to the file system is detected. The violation is
+// recorded in the measurement log. In PanicOnViolation mode, it panics,
+// otherwise it returns EIO.
+func alertIntegrityViolation(ctx context.Context, msg string) error {
+	recordMeasurement(ctx, Measurement{Violation: msg})
+	switch action {
+	case ErrorOnViolation, LogOnViolation:
+		ctx.Warningf("verity: %s", msg)
+	case KillOnViolation:
+		ctx.Warningf("verity: %s, killing the violating task", msg)
+		if t := kernel.TaskFromContext(ctx); t != nil {
+			t.ThreadGroup().SendSignal(kernel.SignalInfoPriv(linux.SIGKILL))
+		}
+	default:
+		panic(msg)
 	}
-	panic(msg)
+	return syserror.EIO
+}
+
+// alertVerificationFailure is like alertIntegrityViolation, but is used when
+// data failed verification against its expected hash. In LogOnViolation mode,
+// the violation is recorded and logged, and nil is returned so that the
+// caller can proceed with the unverified data.
+func alertVerificationFailure(ctx context.Context, msg string) error {
+	if action == LogOnViolation {
+		recordMeasurement(ctx, Measurement{Violation: msg})
+		ctx.Warningf("verity: %s, proceeding with unverified data", msg)
+		return nil
+	}
+	return alertIntegrityViolation(ctx, msg)
 }
 
 // GetFilesystem implements vfs.FilesystemType.GetFilesystem.
@@ -241,6 +293,17 @@ func (fstype FilesystemType) GetFilesystem(ctx context.Context, vfsObj *vfs.Virt
 	}
 	action = iopts.Action
 
+	signed := false
+	if len(iopts.TrustedCertificates) != 0 {
+		if err := verifyRootHashSignature(iopts.Alg, iopts.RootHash, iopts.RootHashSignature, iopts.TrustedCertificates); err != nil {
+			if err := alertVerificationFailure(ctx, fmt.Sprintf("Failed to verify root hash signature: %v", err)); err != nil {
+				return nil, nil, err
+			}
+		} else {
+			signed = true
+		}
+	}
+
 	// Mount the lower file system. The lower file system is wrapped inside
 	// verity, and should not be exposed or connected.
 	mopts := &vfs.MountOptions{
@@ -308,7 +371,7 @@ func (fstype FilesystemType) GetFilesystem(ctx context.Context, vfsObj *vfs.Virt
 		// the root Merkle file, or it's never generated.
 		fs.vfsfs.DecRef(ctx)
 		d.DecRef(ctx)
-		return nil, nil, alertIntegrityViolation("Failed to find root Merkle file")
+		return nil, nil, alertIntegrityViolation(ctx, "Failed to find root Merkle file")
 	}
 	d.lowerMerkleVD = lowerMerkleVD
 
@@ -342,7 +405,7 @@ func (fstype FilesystemType) GetFilesystem(ctx context.Context, vfsObj *vfs.Virt
 			Size: sizeOfStringInt32,
 		})
 		if err == syserror.ENOENT || err == syserror.ENODATA {
-			return nil, nil, alertIntegrityViolation(fmt.Sprintf("Failed to get xattr %s: %v", childrenOffsetXattr, err))
+			return nil, nil, alertIntegrityViolation(ctx, fmt.Sprintf("Failed to get xattr %s: %v", childrenOffsetXattr, err))
 		}
 		if err != nil {
 			return nil, nil, err
@@ -350,7 +413,7 @@ func (fstype FilesystemType) GetFilesystem(ctx context.Context, vfsObj *vfs.Virt
 
 		off, err := strconv.Atoi(offString)
 		if err != nil {
-			return nil, nil, alertIntegrityViolation(fmt.Sprintf("Failed to convert xattr %s to int: %v", childrenOffsetXattr, err))
+			return nil, nil, alertIntegrityViolation(ctx, fmt.Sprintf("Failed to convert xattr %s to int: %v", childrenOffsetXattr, err))
 		}
 
 		sizeString, err := vfsObj.GetXattrAt(ctx, creds, &vfs.PathOperation{
@@ -361,14 +424,14 @@ func (fstype FilesystemType) GetFilesystem(ctx context.Context, vfsObj *vfs.Virt
 			Size: sizeOfStringInt32,
 		})
 		if err == syserror.ENOENT || err == syserror.ENODATA {
-			return nil, nil, alertIntegrityViolation(fmt.Sprintf("Failed to get xattr %s: %v", childrenSizeXattr, err))
+			return nil, nil, alertIntegrityViolation(ctx, fmt.Sprintf("Failed to get xattr %s: %v", childrenSizeXattr, err))
 		}
 		if err != nil {
 			return nil, nil, err
 		}
 		size, err := strconv.Atoi(sizeString)
 		if err != nil {
-			return nil, nil, alertIntegrityViolation(fmt.Sprintf("Failed to convert xattr %s to int: %v", childrenSizeXattr, err))
+			return nil, nil, alertIntegrityViolation(ctx, fmt.Sprintf("Failed to convert xattr %s to int: %v", childrenSizeXattr, err))
 		}
 
 		lowerMerkleFD, err := vfsObj.OpenAt(ctx, fs.creds, &vfs.PathOperation{
@@ -378,7 +441,7 @@ func (fstype FilesystemType) GetFilesystem(ctx context.Context, vfsObj *vfs.Virt
 			Flags: linux.O_RDONLY,
 		})
 		if err == syserror.ENOENT {
-			return nil, nil, alertIntegrityViolation(fmt.Sprintf("Failed to open root Merkle file: %v", err))
+			return nil, nil, alertIntegrityViolation(ctx, fmt.Sprintf("Failed to open root Merkle file: %v", err))
 		}
 		if err != nil {
 			return nil, nil, err
@@ -386,11 +449,11 @@ func (fstype FilesystemType) GetFilesystem(ctx context.Context, vfsObj *vfs.Virt
 
 		childrenNames := make([]byte, size)
 		if _, err := lowerMerkleFD.PRead(ctx, usermem.BytesIOSequence(childrenNames), int64(off), vfs.ReadOptions{}); err != nil {
-			return nil, nil, alertIntegrityViolation(fmt.Sprintf("Failed to read root children map: %v", err))
+			return nil, nil, alertIntegrityViolation(ctx, fmt.Sprintf("Failed to read root children map: %v", err))
 		}
 
 		if err := json.Unmarshal(childrenNames, &d.childrenNames); err != nil {
-			return nil, nil, alertIntegrityViolation(fmt.Sprintf("Failed to deserialize childrenNames: %v", err))
+			return nil, nil, alertIntegrityViolation(ctx, fmt.Sprintf("Failed to deserialize childrenNames: %v", err))
 		}
 
 		if err := fs.verifyStatAndChildrenLocked(ctx, d, stat); err != nil {
@@ -400,6 +463,14 @@ func (fstype FilesystemType) GetFilesystem(ctx context.Context, vfsObj *vfs.Virt
 
 	d.hashMu.Lock()
 	copy(d.hash, iopts.RootHash)
+	if len(d.hash) != 0 {
+		recordMeasurement(ctx, Measurement{
+			Path:   "/",
+			Alg:    iopts.Alg.name(),
+			Hash:   hex.EncodeToString(d.hash),
+			Signed: signed,
+		})
+	}
 	d.hashMu.Unlock()
 	d.vfsd.Init(d)
 
@@ -882,7 +953,7 @@ func (fd *fileDescription) enableVerity(ctx context.Context) (uintptr, error) {
 	// or directory other than the root, the parent Merkle tree file should
 	// have also been initialized.
 	if fd.lowerFD == nil || fd.merkleReader == nil || fd.merkleWriter == nil || (fd.parentMerkleWriter == nil && fd.d != fd.d.fs.rootDentry) {
-		return 0, alertIntegrityViolation("Unexpected verity fd: missing expected underlying fds")
+		return 0, alertIntegrityViolation(ctx, "Unexpected verity fd: missing expected underlying fds")
 	}
 
 	hash, dataSize, err := fd.generateMerkleLocked(ctx)
@@ -955,7 +1026,7 @@ func (fd *fileDescription) measureVerity(ctx context.Context, verityDigest userm
 		if fd.d.fs.allowRuntimeEnable {
 			return 0, syserror.ENODATA
 		}
-		return 0, alertIntegrityViolation("Ioctl measureVerity: no hash found")
+		return 0, alertIntegrityViolation(ctx, "Ioctl measureVerity: no hash found")
 	}
 
 	// The first part of VerityDigest is the metadata.
@@ -1045,7 +1116,7 @@ func (fd *fileDescription) PRead(ctx context.Context, dst usermem.IOSequence, of
 	// contains the expected xattrs. If the xattr does not exist, it
 	// indicates unexpected modifications to the file system.
 	if err == syserror.ENODATA {
-		return 0, alertIntegrityViolation(fmt.Sprintf("Failed to get xattr %s: %v", merkleSizeXattr, err))
+		return 0, alertIntegrityViolation(ctx, fmt.Sprintf("Failed to get xattr %s: %v", merkleSizeXattr, err))
 	}
 	if err != nil {
 		return 0, err
@@ -1055,7 +1126,7 @@ func (fd *fileDescription) PRead(ctx context.Context, dst usermem.IOSequence, of
 	// unexpected modifications to the file system.
 	size, err := strconv.Atoi(dataSize)
 	if err != nil {
-		return 0, alertIntegrityViolation(fmt.Sprintf("Failed to convert xattr %s to int: %v", merkleSizeXattr, err))
+		return 0, alertIntegrityViolation(ctx, fmt.Sprintf("Failed to convert xattr %s to int: %v", merkleSizeXattr, err))
 	}
 
 	dataReader := FileReadWriteSeeker{
@@ -1088,7 +1159,12 @@ func (fd *fileDescription) PRead(ctx context.Context, dst usermem.IOSequence, of
 	})
 	fd.d.hashMu.RUnlock()
 	if err != nil {
-		return 0, alertIntegrityViolation(fmt.Sprintf("Verification failed: %v", err))
+		if err := alertVerificationFailure(ctx, fmt.Sprintf("Verification failed: %v", err)); err != nil {
+			return 0, err
+		}
+		// Proceed with the unverified data.
+		m, err := fd.lowerFD.PRead(ctx, dst.DropFirst64(n), offset+n, vfs.ReadOptions{})
+		return n + m, err
 	}
 	return n, err
 }
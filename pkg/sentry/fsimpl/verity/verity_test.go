@@ -15,8 +15,18 @@
 package verity
 
 import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	crand "crypto/rand"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/x509"
+	"crypto/x509/pkix"
 	"fmt"
 	"io"
+	"math/big"
 	"math/rand"
 	"strconv"
 	"testing"
@@ -801,3 +811,134 @@ func TestOpenRenamedFileFails(t *testing.T) {
 		})
 	}
 }
+
+// countViolations returns the number of violations in the measurement log.
+func countViolations() int {
+	ms, _ := Measurements()
+	n := 0
+	for _, m := range ms {
+		if m.Violation != "" {
+			n++
+		}
+	}
+	return n
+}
+
+// TestPReadModifiedFileLogOnViolation ensures that in LogOnViolation mode,
+// read from a modified verity file returns the modified data, and the
+// violation is recorded in the measurement log.
+func TestPReadModifiedFileLogOnViolation(t *testing.T) {
+	for _, alg := range hashAlgs {
+		vfsObj, root, ctx, err := newVerityRoot(t, alg)
+		if err != nil {
+			t.Fatalf("newVerityRoot: %v", err)
+		}
+		// The action is reset by the next mount.
+		action = LogOnViolation
+
+		filename := "verity-test-file"
+		fd, size, err := newFileFD(ctx, t, vfsObj, root, filename, 0644)
+		if err != nil {
+			t.Fatalf("newFileFD: %v", err)
+		}
+
+		// Enable verity on the file.
+		enableVerity(ctx, t, fd)
+
+		// Open a new lowerFD that's read/writable.
+		lowerFD, err := dentryFromFD(t, fd).openLowerAt(ctx, vfsObj, "", linux.O_RDWR, linux.ModeRegular)
+		if err != nil {
+			t.Fatalf("OpenAt: %v", err)
+		}
+
+		if err := flipRandomBit(ctx, lowerFD, size); err != nil {
+			t.Fatalf("flipRandomBit: %v", err)
+		}
+		want := make([]byte, size)
+		if _, err := lowerFD.PRead(ctx, usermem.BytesIOSequence(want), 0 /* offset */, vfs.ReadOptions{}); err != nil && err != io.EOF {
+			t.Fatalf("lowerFD.PRead: %v", err)
+		}
+
+		// Confirm that read from the modified file succeeds, and that the
+		// violation is logged.
+		violations := countViolations()
+		buf := make([]byte, size)
+		n, err := fd.PRead(ctx, usermem.BytesIOSequence(buf), 0 /* offset */, vfs.ReadOptions{})
+		if err != nil && err != io.EOF {
+			t.Fatalf("fd.PRead: %v", err)
+		}
+		if n != int64(size) || !bytes.Equal(buf, want) {
+			t.Errorf("fd.PRead got %d bytes of unexpected data, want the %d bytes of the modified file", n, size)
+		}
+		if got := countViolations(); got <= violations {
+			t.Errorf("got %d violations in the measurement log, want more than %d", got, violations)
+		}
+	}
+}
+
+// newTestCertificate returns a private key and a self-signed DER encoded
+// certificate for it.
+func newTestCertificate(t *testing.T) (*ecdsa.PrivateKey, []byte) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), crand.Reader)
+	if err != nil {
+		t.Fatalf("ecdsa.GenerateKey: %v", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "verity-test"},
+		NotBefore:    time.Now(),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	cert, err := x509.CreateCertificate(crand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("x509.CreateCertificate: %v", err)
+	}
+	return key, cert
+}
+
+// signRootHash signs rootHash with key as expected by verifyRootHashSignature.
+func signRootHash(t *testing.T, key *ecdsa.PrivateKey, alg HashAlgorithm, rootHash []byte) []byte {
+	t.Helper()
+	msg := formattedDigest(alg, rootHash)
+	var digest []byte
+	var hash crypto.Hash
+	if alg == SHA512 {
+		sum := sha512.Sum512(msg)
+		digest, hash = sum[:], crypto.SHA512
+	} else {
+		sum := sha256.Sum256(msg)
+		digest, hash = sum[:], crypto.SHA256
+	}
+	sig, err := key.Sign(crand.Reader, digest, hash)
+	if err != nil {
+		t.Fatalf("key.Sign: %v", err)
+	}
+	return sig
+}
+
+// TestRootHashSignature ensures that root hash signatures are verified against
+// the trusted certificates.
+func TestRootHashSignature(t *testing.T) {
+	key, cert := newTestCertificate(t)
+	_, otherCert := newTestCertificate(t)
+	for _, alg := range hashAlgs {
+		rootHash := make([]byte, 32)
+		rand.Read(rootHash)
+		sig := signRootHash(t, key, alg, rootHash)
+
+		if err := verifyRootHashSignature(alg, rootHash, sig, [][]byte{otherCert, cert}); err != nil {
+			t.Errorf("verifyRootHashSignature with signing certificate: %v", err)
+		}
+		if err := verifyRootHashSignature(alg, rootHash, sig, [][]byte{otherCert}); err == nil {
+			t.Errorf("verifyRootHashSignature without signing certificate succeeded, expected failure")
+		}
+		if err := verifyRootHashSignature(alg, rootHash, nil, [][]byte{cert}); err == nil {
+			t.Errorf("verifyRootHashSignature without signature succeeded, expected failure")
+		}
+		rootHash[0] ^= 1
+		if err := verifyRootHashSignature(alg, rootHash, sig, [][]byte{cert}); err == nil {
+			t.Errorf("verifyRootHashSignature with modified root hash succeeded, expected failure")
+		}
+	}
+}
@@ -16,6 +16,8 @@
 package inet
 
 import (
+	"time"
+
 	"gvisor.dev/gvisor/pkg/tcpip"
 	"gvisor.dev/gvisor/pkg/tcpip/stack"
 )
@@ -31,6 +33,15 @@ type Stack interface {
 	// interface indexes to a slice of associated interface address properties.
 	InterfaceAddrs() map[int32][]InterfaceAddr
 
+	// AddInterface creates a network interface with the name, hardware
+	// address and MTU of iface, if set, and returns its index. Only tunnel
+	// interfaces can be created.
+	AddInterface(iface Interface) (int32, error)
+
+	// RemoveInterface removes the network interface identified by idx. Only
+	// interfaces created with AddInterface can be removed.
+	RemoveInterface(idx int32) error
+
 	// AddInterfaceAddr adds an address to the network interface identified by
 	// idx.
 	AddInterfaceAddr(idx int32, addr InterfaceAddr) error
@@ -39,6 +50,26 @@ type Stack interface {
 	// identified by idx.
 	RemoveInterfaceAddr(idx int32, addr InterfaceAddr) error
 
+	// SetInterfaceMTU sets the MTU of the network interface identified by
+	// idx.
+	SetInterfaceMTU(idx int32, mtu uint32) error
+
+	// SetInterfaceUp brings the network interface identified by idx up or
+	// down.
+	SetInterfaceUp(idx int32, up bool) error
+
+	// Neighbors returns all neighbor entries as a mapping from interface
+	// indexes to a slice of associated neighbor entries.
+	Neighbors() map[int32][]Neighbor
+
+	// AddNeighbor adds a permanent neighbor entry to the network interface
+	// identified by idx.
+	AddNeighbor(idx int32, neigh Neighbor) error
+
+	// RemoveNeighbor removes the neighbor entry for neigh.Addr from the
+	// network interface identified by idx.
+	RemoveNeighbor(idx int32, neigh Neighbor) error
+
 	// SupportsIPv6 returns true if the stack supports IPv6 connectivity.
 	SupportsIPv6() bool
 
@@ -69,12 +100,116 @@ type Stack interface {
 	// SetTCPRecovery attempts to change TCP loss detection algorithm.
 	SetTCPRecovery(recovery TCPLossRecovery) error
 
+	// TCPECN returns the TCP Explicit Congestion Notification mode.
+	TCPECN() (TCPECNMode, error)
+
+	// SetTCPECN attempts to change the TCP Explicit Congestion Notification
+	// mode.
+	SetTCPECN(mode TCPECNMode) error
+
+	// TCPECNFallback returns true if retransmitted TCP SYNs stop requesting
+	// Explicit Congestion Notification.
+	TCPECNFallback() (bool, error)
+
+	// SetTCPECNFallback attempts to change whether retransmitted TCP SYNs
+	// stop requesting Explicit Congestion Notification.
+	SetTCPECNFallback(enabled bool) error
+
+	// TCPSynCookies returns when listening TCP sockets answer SYNs with
+	// SYN cookies.
+	TCPSynCookies() (TCPSynCookiesMode, error)
+
+	// SetTCPSynCookies attempts to change when listening TCP sockets answer
+	// SYNs with SYN cookies.
+	SetTCPSynCookies(mode TCPSynCookiesMode) error
+
+	// TCPAbortOnOverflow returns true if the final ACK of TCP handshakes is
+	// answered with a RST when the accept queue is full.
+	TCPAbortOnOverflow() (bool, error)
+
+	// SetTCPAbortOnOverflow attempts to change whether the final ACK of TCP
+	// handshakes is answered with a RST when the accept queue is full.
+	SetTCPAbortOnOverflow(enabled bool) error
+
+	// TCPMigrateReq returns true if the connections queued on a closing
+	// listening TCP socket are migrated to another socket of its
+	// SO_REUSEPORT group.
+	TCPMigrateReq() (bool, error)
+
+	// SetTCPMigrateReq attempts to change whether the connections queued on
+	// a closing listening TCP socket are migrated to another socket of its
+	// SO_REUSEPORT group.
+	SetTCPMigrateReq(enabled bool) error
+
 	// Statistics reports stack statistics.
 	Statistics(stat interface{}, arg string) error
 
 	// RouteTable returns the network stack's route table.
 	RouteTable() []Route
 
+	// AddRoute adds a route to the network stack's route table.
+	AddRoute(route Route) error
+
+	// RemoveRoute removes the routes matching route from the network stack's
+	// route table. Unset fields of route match any value.
+	RemoveRoute(route Route) error
+
+	// RouteRules returns the network stack's routing policy rules, in the
+	// order they are evaluated.
+	RouteRules() []Rule
+
+	// AddRouteRule adds a routing policy rule to the network stack.
+	AddRouteRule(rule Rule) error
+
+	// RemoveRouteRule removes a routing policy rule equal to rule from the
+	// network stack.
+	RemoveRouteRule(rule Rule) error
+
+	// QueueingDisciplines returns the root queueing disciplines of the
+	// network interfaces as a mapping from interface indexes to qdiscs.
+	// Interfaces without a qdisc are omitted.
+	QueueingDisciplines() map[int32]QueueingDiscipline
+
+	// SetQueueingDiscipline sets the root queueing discipline of the network
+	// interface identified by idx, or removes it if qdisc is nil.
+	SetQueueingDiscipline(idx int32, qdisc *QueueingDiscipline) error
+
+	// ConnTrackEntries returns the connections tracked by the network stack.
+	ConnTrackEntries() ([]ConnTrackEntry, error)
+
+	// RemoveConnTrackEntries removes the tracked connections for which match
+	// returns true, and returns the number of removed connections.
+	RemoveConnTrackEntries(match func(*ConnTrackEntry) bool) (int, error)
+
+	// ConnTrackSettings returns the connection tracking settings.
+	ConnTrackSettings() (ConnTrackSettings, error)
+
+	// SetConnTrackSettings sets the connection tracking settings.
+	SetConnTrackSettings(settings ConnTrackSettings) error
+
+	// ConnTrackStats returns the connection tracking statistics.
+	ConnTrackStats() (ConnTrackStats, error)
+
+	// AddressLabels returns the IPv6 address label table used for source
+	// address selection, in the order entries are matched.
+	AddressLabels() ([]AddressLabel, error)
+
+	// AddAddressLabel adds an entry to the IPv6 address label table. If an
+	// entry exists for the same prefix and interface, it is replaced if
+	// replace is true, otherwise EEXIST is returned.
+	AddAddressLabel(label AddressLabel, replace bool) error
+
+	// RemoveAddressLabel removes the entry of the IPv6 address label table for
+	// the prefix and interface of label.
+	RemoveAddressLabel(label AddressLabel) error
+
+	// IPv6TempAddrSettings returns the IPv6 temporary address settings.
+	IPv6TempAddrSettings() (IPv6TempAddrSettings, error)
+
+	// SetIPv6TempAddrSettings sets the IPv6 temporary address settings of all
+	// interfaces.
+	SetIPv6TempAddrSettings(settings IPv6TempAddrSettings) error
+
 	// Resume restarts the network stack after restore.
 	Resume()
 
@@ -112,6 +247,38 @@ type Interface struct {
 
 	// MTU is the maximum transmission unit.
 	MTU uint32
+
+	// Tunnel is the configuration of a tunnel interface. It is nil for
+	// other interfaces.
+	Tunnel *Tunnel
+}
+
+// Tunnel contains the configuration of a tunnel network interface.
+type Tunnel struct {
+	// Kind is the kind of tunnel, "vxlan" or "geneve" as in IFLA_INFO_KIND.
+	Kind string
+
+	// VNI is the virtual network identifier of the tunnel.
+	VNI uint32
+
+	// Remote is the address of the remote tunnel endpoint, or the multicast
+	// group, that frames of unknown destinations are sent to. It may be
+	// empty.
+	Remote []byte
+
+	// Local is the source address of encapsulated packets. It may be empty.
+	Local []byte
+
+	// Port is the UDP port of the tunnel.
+	Port uint16
+
+	// Link is the index of the interface encapsulated packets are sent
+	// through, or zero for any interface.
+	Link int32
+
+	// Learning indicates whether the remote tunnel endpoints of received
+	// frames are learnt.
+	Learning bool
 }
 
 // InterfaceAddr contains information about a network interface address.
@@ -129,6 +296,21 @@ type InterfaceAddr struct {
 	Addr []byte
 }
 
+// Neighbor contains information about a neighbor entry.
+type Neighbor struct {
+	// Family is the address family, a Linux AF_* constant.
+	Family uint8
+
+	// State is the state of the entry, a Linux NUD_* constant.
+	State uint16
+
+	// Addr is the network address of the neighbor.
+	Addr []byte
+
+	// LinkAddr is the hardware address of the neighbor.
+	LinkAddr []byte
+}
+
 // TCPBufferSize contains settings controlling TCP buffer sizing.
 //
 // +stateify savable
@@ -162,7 +344,7 @@ type Route struct {
 	TOS uint8
 
 	// Table is the routing table ID.
-	Table uint8
+	Table uint32
 
 	// Protocol is the route origin, a Linux RTPROT_* constant.
 	Protocol uint8
@@ -189,6 +371,197 @@ type Route struct {
 	GatewayAddr []byte
 }
 
+// Rule contains information about a routing policy rule.
+type Rule struct {
+	// Family is the address family, a Linux AF_* constant.
+	Family uint8
+
+	// DstLen is the length of the destination address.
+	DstLen uint8
+
+	// SrcLen is the length of the source address.
+	SrcLen uint8
+
+	// Flags are rule flags, Linux FIB_RULE_* constants.
+	Flags uint32
+
+	// Priority is the rule priority (FRA_PRIORITY).
+	Priority uint32
+
+	// Table is the routing table ID the rule selects (FRA_TABLE).
+	Table uint32
+
+	// DstAddr is the destination address selector (FRA_DST).
+	DstAddr []byte
+
+	// SrcAddr is the source address selector (FRA_SRC).
+	SrcAddr []byte
+
+	// Mark and MarkMask are the packet mark selector (FRA_FWMARK and
+	// FRA_FWMASK).
+	Mark     uint32
+	MarkMask uint32
+
+	// InputInterface is the input interface index selector, from
+	// FRA_IIFNAME. Zero matches any interface.
+	InputInterface int32
+
+	// OutputInterface is the output interface index selector, from
+	// FRA_OIFNAME. Zero matches any interface.
+	OutputInterface int32
+
+	// SuppressPrefixLen is the longest destination prefix length of the
+	// routes ignored by the rule (FRA_SUPPRESS_PREFIXLEN), or -1 if unset.
+	SuppressPrefixLen int32
+}
+
+// AddressLabel is an entry of the IPv6 address label table, which assigns
+// labels to addresses for source address selection (RFC 6724 section 2.1).
+type AddressLabel struct {
+	// Prefix is the prefix of the addresses the label applies to (IFAL_ADDRESS).
+	Prefix []byte
+
+	// PrefixLen is the length of Prefix.
+	PrefixLen uint8
+
+	// Interface restricts the entry to the addresses used on an interface.
+	// Zero matches any interface.
+	Interface int32
+
+	// Label is the label of the addresses (IFAL_LABEL).
+	Label uint32
+}
+
+// IPv6TempAddrSettings contains the settings of IPv6 temporary addresses
+// (RFC 4941), as in the use_tempaddr, temp_valid_lft and temp_prefered_lft
+// sysctls.
+type IPv6TempAddrSettings struct {
+	// UseTempAddr is 0 if temporary addresses are not generated, 1 if they
+	// are generated and 2 if they are also preferred over public addresses.
+	UseTempAddr int32
+
+	// ValidLifetime and PreferredLifetime are the maximum lifetimes of
+	// temporary addresses.
+	ValidLifetime     time.Duration
+	PreferredLifetime time.Duration
+}
+
+// QueueingDiscipline contains information about a queueing discipline (qdisc)
+// of the packets sent through a network interface. The fields used depend on
+// the kind of the qdisc.
+type QueueingDiscipline struct {
+	// Kind is the kind of the qdisc as in tc(8): "tbf", "netem", "fq" or
+	// "fq_codel".
+	Kind string
+
+	// Limit is the size of the queue, in bytes for tbf and in packets for the
+	// other kinds.
+	Limit uint32
+
+	// Rate is the rate of tbf or the maximum rate of each flow of fq, in
+	// bytes per second.
+	Rate uint64
+
+	// Burst is the size of the bucket of tbf, in bytes.
+	Burst uint32
+
+	// Latency and Jitter are the delay added to the packets by netem.
+	Latency time.Duration
+	Jitter  time.Duration
+
+	// Loss and Duplicate are the probabilities of netem to drop and duplicate
+	// packets, scaled to [0, math.MaxUint32].
+	Loss      uint32
+	Duplicate uint32
+
+	// FlowLimit is the number of packets of each flow fq can queue.
+	FlowLimit uint32
+
+	// Quantum is the number of bytes each flow can send per round of fq and
+	// fq_codel.
+	Quantum uint32
+
+	// Flows is the number of flow queues of fq_codel.
+	Flows uint32
+
+	// Target and Interval are the CoDel parameters of fq_codel.
+	Target   time.Duration
+	Interval time.Duration
+}
+
+// ConnTrackTuple identifies a tracked connection in one direction.
+type ConnTrackTuple struct {
+	SrcAddr []byte
+	SrcPort uint16
+	DstAddr []byte
+	DstPort uint16
+}
+
+// ConnTrackEntry contains information about a tracked connection.
+type ConnTrackEntry struct {
+	// ID uniquely identifies the connection (CTA_ID).
+	ID uint32
+
+	// Family is the address family of the connection, AF_INET or AF_INET6.
+	Family uint8
+
+	// Protocol is the transport protocol of the connection.
+	Protocol uint8
+
+	// Original is the tuple of packets in the original direction.
+	Original ConnTrackTuple
+
+	// Reply is the tuple expected for packets in the reply direction.
+	Reply ConnTrackTuple
+
+	// TCPState is the state of TCP connections (TCP_CONNTRACK_*).
+	TCPState uint8
+
+	// Status is the status of the connection (IPS_*).
+	Status uint32
+
+	// Timeout is the time left before the connection is deleted, unless a
+	// packet is seen.
+	Timeout time.Duration
+}
+
+// ConnTrackSettings contains the connection tracking settings, as in the
+// nf_conntrack sysctls.
+type ConnTrackSettings struct {
+	// Max is the maximum number of tracked connections, or 0 if it is not
+	// limited.
+	Max uint32
+
+	// Buckets is the size of the hash table of connections.
+	Buckets uint32
+
+	// TCPTimeoutSynSent, TCPTimeoutEstablished, TCPTimeoutTimeWait and
+	// TCPTimeoutClose are how long TCP connections are kept without seeing
+	// a packet in the corresponding states.
+	TCPTimeoutSynSent     time.Duration
+	TCPTimeoutEstablished time.Duration
+	TCPTimeoutTimeWait    time.Duration
+	TCPTimeoutClose       time.Duration
+}
+
+// ConnTrackStats contains the connection tracking statistics.
+type ConnTrackStats struct {
+	// Entries is the number of tracked connections.
+	Entries uint32
+
+	// Found is the number of packets of tracked connections.
+	Found uint64
+
+	// Insert is the number of connections that were tracked.
+	Insert uint64
+
+	// InsertFailed is the number of connections that failed to be tracked.
+	InsertFailed uint64
+
+	// Drop is the number of packets dropped because the table was full.
+	Drop uint64
+}
+
 // Below SNMP metrics are from Linux/usr/include/linux/snmp.h.
 
 // StatSNMPIP describes Ip line of /proc/net/snmp.
@@ -219,3 +592,11 @@ const (
 	TCP_RACK_STATIC_REO_WND
 	TCP_RACK_NO_DUPTHRESH
 )
+
+// TCPECNMode indicates whether TCP negotiates Explicit Congestion
+// Notification, with the values of /proc/sys/net/ipv4/tcp_ecn.
+type TCPECNMode int32
+
+// TCPSynCookiesMode indicates when TCP SYN cookies are sent, with the values
+// of /proc/sys/net/ipv4/tcp_syncookies.
+type TCPSynCookiesMode int32
@@ -14,6 +14,10 @@
 
 package inet
 
+import (
+	"gvisor.dev/gvisor/pkg/sentry/kernel/auth"
+)
+
 // Namespace represents a network namespace. See network_namespaces(7).
 //
 // +stateify savable
@@ -30,28 +34,40 @@ type Namespace struct {
 
 	// isRoot indicates whether this is the root network namespace.
 	isRoot bool
+
+	// userNS is the user namespace that owns this network namespace. The
+	// userNS pointer is immutable.
+	userNS *auth.UserNamespace
 }
 
-// NewRootNamespace creates the root network namespace, with creator
-// allowing new network namespaces to be created. If creator is nil, no
-// networking will function if the network is namespaced.
-func NewRootNamespace(stack Stack, creator NetworkStackCreator) *Namespace {
+// NewRootNamespace creates the root network namespace, owned by userNS, with
+// creator allowing new network namespaces to be created. If creator is nil,
+// no networking will function if the network is namespaced.
+func NewRootNamespace(stack Stack, creator NetworkStackCreator, userNS *auth.UserNamespace) *Namespace {
 	return &Namespace{
 		stack:   stack,
 		creator: creator,
 		isRoot:  true,
+		userNS:  userNS,
 	}
 }
 
-// NewNamespace creates a new network namespace from the root.
-func NewNamespace(root *Namespace) *Namespace {
+// NewNamespace creates a new network namespace from the root, owned by
+// userNS.
+func NewNamespace(root *Namespace, userNS *auth.UserNamespace) *Namespace {
 	n := &Namespace{
 		creator: root.creator,
+		userNS:  userNS,
 	}
 	n.init()
 	return n
 }
 
+// UserNamespace returns the user namespace that owns n.
+func (n *Namespace) UserNamespace() *auth.UserNamespace {
+	return n.userNS
+}
+
 // Stack returns the network stack of n. Stack may return nil if no network
 // stack is configured.
 func (n *Namespace) Stack() Stack {
@@ -100,3 +116,12 @@ type NetworkStackCreator interface {
 	// CreateStack creates a new network stack for a network namespace.
 	CreateStack() (Stack, error)
 }
+
+// NamespaceFile is implemented by files that refer to a namespace, such as
+// the files in /proc/[pid]/ns. It allows the namespace to be joined with
+// setns(2).
+type NamespaceFile interface {
+	// NetworkNamespace returns the network namespace the file refers to, or
+	// nil if it refers to another kind of namespace.
+	NetworkNamespace() *Namespace
+}
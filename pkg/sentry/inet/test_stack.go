@@ -18,6 +18,7 @@ import (
 	"bytes"
 	"fmt"
 
+	"gvisor.dev/gvisor/pkg/abi/linux"
 	"gvisor.dev/gvisor/pkg/tcpip"
 	"gvisor.dev/gvisor/pkg/tcpip/stack"
 )
@@ -26,12 +27,25 @@ import (
 type TestStack struct {
 	InterfacesMap     map[int32]Interface
 	InterfaceAddrsMap map[int32][]InterfaceAddr
+	NeighborsMap      map[int32][]Neighbor
 	RouteList         []Route
+	RuleList          []Rule
+	QdiscsMap         map[int32]QueueingDiscipline
+	ConnTrackList     []ConnTrackEntry
+	ConnTrack         ConnTrackSettings
+	ConnTrackStat     ConnTrackStats
+	AddressLabelList  []AddressLabel
+	TempAddr          IPv6TempAddrSettings
 	SupportsIPv6Flag  bool
 	TCPRecvBufSize    TCPBufferSize
 	TCPSendBufSize    TCPBufferSize
 	TCPSACKFlag       bool
 	Recovery          TCPLossRecovery
+	ECN               TCPECNMode
+	ECNFallback       bool
+	SynCookies        TCPSynCookiesMode
+	AbortOnOverflow   bool
+	MigrateReq        bool
 	IPForwarding      bool
 }
 
@@ -42,6 +56,8 @@ func NewTestStack() *TestStack {
 	return &TestStack{
 		InterfacesMap:     make(map[int32]Interface),
 		InterfaceAddrsMap: make(map[int32][]InterfaceAddr),
+		NeighborsMap:      make(map[int32][]Neighbor),
+		QdiscsMap:         make(map[int32]QueueingDiscipline),
 	}
 }
 
@@ -55,6 +71,26 @@ func (s *TestStack) InterfaceAddrs() map[int32][]InterfaceAddr {
 	return s.InterfaceAddrsMap
 }
 
+// AddInterface implements Stack.AddInterface.
+func (s *TestStack) AddInterface(iface Interface) (int32, error) {
+	idx := int32(len(s.InterfacesMap) + 1)
+	for _, ok := s.InterfacesMap[idx]; ok; _, ok = s.InterfacesMap[idx] {
+		idx++
+	}
+	s.InterfacesMap[idx] = iface
+	return idx, nil
+}
+
+// RemoveInterface implements Stack.RemoveInterface.
+func (s *TestStack) RemoveInterface(idx int32) error {
+	if _, ok := s.InterfacesMap[idx]; !ok {
+		return fmt.Errorf("unknown idx: %d", idx)
+	}
+	delete(s.InterfacesMap, idx)
+	delete(s.InterfaceAddrsMap, idx)
+	return nil
+}
+
 // AddInterfaceAddr implements Stack.AddInterfaceAddr.
 func (s *TestStack) AddInterfaceAddr(idx int32, addr InterfaceAddr) error {
 	s.InterfaceAddrsMap[idx] = append(s.InterfaceAddrsMap[idx], addr)
@@ -79,6 +115,61 @@ func (s *TestStack) RemoveInterfaceAddr(idx int32, addr InterfaceAddr) error {
 	return nil
 }
 
+// SetInterfaceMTU implements Stack.SetInterfaceMTU.
+func (s *TestStack) SetInterfaceMTU(idx int32, mtu uint32) error {
+	i, ok := s.InterfacesMap[idx]
+	if !ok {
+		return fmt.Errorf("unknown idx: %d", idx)
+	}
+	i.MTU = mtu
+	s.InterfacesMap[idx] = i
+	return nil
+}
+
+// SetInterfaceUp implements Stack.SetInterfaceUp.
+func (s *TestStack) SetInterfaceUp(idx int32, up bool) error {
+	i, ok := s.InterfacesMap[idx]
+	if !ok {
+		return fmt.Errorf("unknown idx: %d", idx)
+	}
+	if up {
+		i.Flags |= linux.IFF_UP
+	} else {
+		i.Flags &^= linux.IFF_UP
+	}
+	s.InterfacesMap[idx] = i
+	return nil
+}
+
+// Neighbors implements Stack.Neighbors.
+func (s *TestStack) Neighbors() map[int32][]Neighbor {
+	return s.NeighborsMap
+}
+
+// AddNeighbor implements Stack.AddNeighbor.
+func (s *TestStack) AddNeighbor(idx int32, neigh Neighbor) error {
+	s.NeighborsMap[idx] = append(s.NeighborsMap[idx], neigh)
+	return nil
+}
+
+// RemoveNeighbor implements Stack.RemoveNeighbor.
+func (s *TestStack) RemoveNeighbor(idx int32, neigh Neighbor) error {
+	neighbors, ok := s.NeighborsMap[idx]
+	if !ok {
+		return fmt.Errorf("unknown idx: %d", idx)
+	}
+
+	var filteredNeighbors []Neighbor
+	for _, n := range neighbors {
+		if !bytes.Equal(n.Addr, neigh.Addr) {
+			filteredNeighbors = append(filteredNeighbors, n)
+		}
+	}
+	s.NeighborsMap[idx] = filteredNeighbors
+
+	return nil
+}
+
 // SupportsIPv6 implements Stack.SupportsIPv6.
 func (s *TestStack) SupportsIPv6() bool {
 	return s.SupportsIPv6Flag
@@ -128,6 +219,61 @@ func (s *TestStack) SetTCPRecovery(recovery TCPLossRecovery) error {
 	return nil
 }
 
+// TCPECN implements Stack.TCPECN.
+func (s *TestStack) TCPECN() (TCPECNMode, error) {
+	return s.ECN, nil
+}
+
+// SetTCPECN implements Stack.SetTCPECN.
+func (s *TestStack) SetTCPECN(mode TCPECNMode) error {
+	s.ECN = mode
+	return nil
+}
+
+// TCPECNFallback implements Stack.TCPECNFallback.
+func (s *TestStack) TCPECNFallback() (bool, error) {
+	return s.ECNFallback, nil
+}
+
+// SetTCPECNFallback implements Stack.SetTCPECNFallback.
+func (s *TestStack) SetTCPECNFallback(enabled bool) error {
+	s.ECNFallback = enabled
+	return nil
+}
+
+// TCPSynCookies implements Stack.TCPSynCookies.
+func (s *TestStack) TCPSynCookies() (TCPSynCookiesMode, error) {
+	return s.SynCookies, nil
+}
+
+// SetTCPSynCookies implements Stack.SetTCPSynCookies.
+func (s *TestStack) SetTCPSynCookies(mode TCPSynCookiesMode) error {
+	s.SynCookies = mode
+	return nil
+}
+
+// TCPAbortOnOverflow implements Stack.TCPAbortOnOverflow.
+func (s *TestStack) TCPAbortOnOverflow() (bool, error) {
+	return s.AbortOnOverflow, nil
+}
+
+// SetTCPAbortOnOverflow implements Stack.SetTCPAbortOnOverflow.
+func (s *TestStack) SetTCPAbortOnOverflow(enabled bool) error {
+	s.AbortOnOverflow = enabled
+	return nil
+}
+
+// TCPMigrateReq implements Stack.TCPMigrateReq.
+func (s *TestStack) TCPMigrateReq() (bool, error) {
+	return s.MigrateReq, nil
+}
+
+// SetTCPMigrateReq implements Stack.SetTCPMigrateReq.
+func (s *TestStack) SetTCPMigrateReq(enabled bool) error {
+	s.MigrateReq = enabled
+	return nil
+}
+
 // Statistics implements inet.Stack.Statistics.
 func (s *TestStack) Statistics(stat interface{}, arg string) error {
 	return nil
@@ -138,6 +284,137 @@ func (s *TestStack) RouteTable() []Route {
 	return s.RouteList
 }
 
+// AddRoute implements Stack.AddRoute.
+func (s *TestStack) AddRoute(route Route) error {
+	s.RouteList = append(s.RouteList, route)
+	return nil
+}
+
+// RemoveRoute implements Stack.RemoveRoute.
+func (s *TestStack) RemoveRoute(route Route) error {
+	var filteredRoutes []Route
+	for _, rt := range s.RouteList {
+		if rt.Family != route.Family || rt.DstLen != route.DstLen || !bytes.Equal(rt.DstAddr, route.DstAddr) {
+			filteredRoutes = append(filteredRoutes, rt)
+		}
+	}
+	s.RouteList = filteredRoutes
+	return nil
+}
+
+// RouteRules implements Stack.RouteRules.
+func (s *TestStack) RouteRules() []Rule {
+	return s.RuleList
+}
+
+// AddRouteRule implements Stack.AddRouteRule.
+func (s *TestStack) AddRouteRule(rule Rule) error {
+	s.RuleList = append(s.RuleList, rule)
+	return nil
+}
+
+// RemoveRouteRule implements Stack.RemoveRouteRule.
+func (s *TestStack) RemoveRouteRule(rule Rule) error {
+	for i, r := range s.RuleList {
+		if r.Family == rule.Family && r.Priority == rule.Priority && r.Table == rule.Table && bytes.Equal(r.SrcAddr, rule.SrcAddr) && bytes.Equal(r.DstAddr, rule.DstAddr) {
+			s.RuleList = append(s.RuleList[:i], s.RuleList[i+1:]...)
+			return nil
+		}
+	}
+	return fmt.Errorf("rule not found: %+v", rule)
+}
+
+// QueueingDisciplines implements Stack.QueueingDisciplines.
+func (s *TestStack) QueueingDisciplines() map[int32]QueueingDiscipline {
+	return s.QdiscsMap
+}
+
+// SetQueueingDiscipline implements Stack.SetQueueingDiscipline.
+func (s *TestStack) SetQueueingDiscipline(idx int32, qdisc *QueueingDiscipline) error {
+	if qdisc == nil {
+		delete(s.QdiscsMap, idx)
+		return nil
+	}
+	s.QdiscsMap[idx] = *qdisc
+	return nil
+}
+
+// ConnTrackEntries implements Stack.ConnTrackEntries.
+func (s *TestStack) ConnTrackEntries() ([]ConnTrackEntry, error) {
+	return s.ConnTrackList, nil
+}
+
+// RemoveConnTrackEntries implements Stack.RemoveConnTrackEntries.
+func (s *TestStack) RemoveConnTrackEntries(match func(*ConnTrackEntry) bool) (int, error) {
+	var kept []ConnTrackEntry
+	for i := range s.ConnTrackList {
+		if !match(&s.ConnTrackList[i]) {
+			kept = append(kept, s.ConnTrackList[i])
+		}
+	}
+	removed := len(s.ConnTrackList) - len(kept)
+	s.ConnTrackList = kept
+	return removed, nil
+}
+
+// ConnTrackSettings implements Stack.ConnTrackSettings.
+func (s *TestStack) ConnTrackSettings() (ConnTrackSettings, error) {
+	return s.ConnTrack, nil
+}
+
+// SetConnTrackSettings implements Stack.SetConnTrackSettings.
+func (s *TestStack) SetConnTrackSettings(settings ConnTrackSettings) error {
+	s.ConnTrack = settings
+	return nil
+}
+
+// ConnTrackStats implements Stack.ConnTrackStats.
+func (s *TestStack) ConnTrackStats() (ConnTrackStats, error) {
+	return s.ConnTrackStat, nil
+}
+
+// AddressLabels implements Stack.AddressLabels.
+func (s *TestStack) AddressLabels() ([]AddressLabel, error) {
+	return s.AddressLabelList, nil
+}
+
+// AddAddressLabel implements Stack.AddAddressLabel.
+func (s *TestStack) AddAddressLabel(label AddressLabel, replace bool) error {
+	for i, l := range s.AddressLabelList {
+		if l.PrefixLen == label.PrefixLen && l.Interface == label.Interface && bytes.Equal(l.Prefix, label.Prefix) {
+			if !replace {
+				return fmt.Errorf("address label exists: %+v", l)
+			}
+			s.AddressLabelList[i] = label
+			return nil
+		}
+	}
+	s.AddressLabelList = append(s.AddressLabelList, label)
+	return nil
+}
+
+// RemoveAddressLabel implements Stack.RemoveAddressLabel.
+func (s *TestStack) RemoveAddressLabel(label AddressLabel) error {
+	for i, l := range s.AddressLabelList {
+		if l.PrefixLen == label.PrefixLen && l.Interface == label.Interface && bytes.Equal(l.Prefix, label.Prefix) {
+			s.AddressLabelList = append(s.AddressLabelList[:i], s.AddressLabelList[i+1:]...)
+			return nil
+		}
+	}
+	return fmt.Errorf("address label not found: %+v", label)
+}
+
+// IPv6TempAddrSettings implements Stack.IPv6TempAddrSettings.
+func (s *TestStack) IPv6TempAddrSettings() (IPv6TempAddrSettings, error) {
+	return s.TempAddr, nil
+}
+
+// SetIPv6TempAddrSettings implements Stack.SetIPv6TempAddrSettings.
+func (s *TestStack) SetIPv6TempAddrSettings(settings IPv6TempAddrSettings) error {
+	s.TempAddr = settings
+	return nil
+}
+
 // Resume implements Stack.Resume.
 func (s *TestStack) Resume() {}
 
@@ -0,0 +1,66 @@
+// Copyright 2020 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kernel
+
+import (
+	"sync/atomic"
+
+	"gvisor.dev/gvisor/pkg/sentry/arch"
+)
+
+// Auditor generates audit records for syscalls.
+type Auditor interface {
+	// AuditSyscall is called after t executes a syscall, which returned rval
+	// and err, while auditing is enabled by Kernel.SetAuditEnabled. For
+	// execve, it is called before t's image is replaced.
+	AuditSyscall(t *Task, sysno uintptr, args arch.SyscallArguments, rval uintptr, err error)
+}
+
+// Auditor returns the Auditor of k's tasks. If k has none, it is set to the
+// Auditor returned by newAuditor.
+func (k *Kernel) Auditor(newAuditor func() Auditor) Auditor {
+	k.auditMu.Lock()
+	defer k.auditMu.Unlock()
+	if k.auditor == nil {
+		k.auditor = newAuditor()
+	}
+	return k.auditor
+}
+
+// SetAuditEnabled sets whether k's Auditor is called for the syscalls of k's
+// tasks.
+//
+// Preconditions: k.Auditor has been called.
+func (k *Kernel) SetAuditEnabled(enabled bool) {
+	var v uint32
+	if enabled {
+		v = 1
+	}
+	atomic.StoreUint32(&k.auditEnabled, v)
+}
+
+// auditing returns true if the syscalls of k's tasks should be passed to k's
+// Auditor.
+func (k *Kernel) auditing() bool {
+	return atomic.LoadUint32(&k.auditEnabled) != 0
+}
+
+// audit passes a completed syscall to k's Auditor.
+//
+// Preconditions: t.k.auditing() returned true.
+func (t *Task) audit(sysno uintptr, args arch.SyscallArguments, rval uintptr, err error) {
+	// auditor is set before auditing is enabled, and never changes.
+	t.k.auditor.AuditSyscall(t, sysno, args, rval, err)
+}
@@ -0,0 +1,112 @@
+// Copyright 2020 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kernel
+
+import (
+	"gvisor.dev/gvisor/pkg/sentry/usage"
+)
+
+// Cgroup is a control group of the emulated cgroup hierarchy, which thread
+// groups are members of. It is implemented by the filesystem that exposes the
+// hierarchy, if any.
+type Cgroup interface {
+	// Path returns the path of the cgroup, relative to the root of the
+	// hierarchy, e.g. "/" or "/docker/abc".
+	Path() string
+}
+
+// SetCgroupRoot sets the root of the emulated cgroup hierarchy, which thread
+// groups created afterwards are members of, unless they inherit the cgroup of
+// their parent.
+//
+// Preconditions: No thread groups have been created.
+func (k *Kernel) SetCgroupRoot(root Cgroup) {
+	k.cgroupRoot = root
+}
+
+// CgroupRoot returns the root of the emulated cgroup hierarchy, or nil if
+// there is none.
+func (k *Kernel) CgroupRoot() Cgroup {
+	return k.cgroupRoot
+}
+
+// Cgroup returns the cgroup tg is a member of, or nil if there is no emulated
+// cgroup hierarchy.
+func (tg *ThreadGroup) Cgroup() Cgroup {
+	tg.pidns.owner.mu.RLock()
+	defer tg.pidns.owner.mu.RUnlock()
+	return tg.cgroup
+}
+
+// SetCgroup moves tg to cg.
+func (tg *ThreadGroup) SetCgroup(cg Cgroup) {
+	tg.pidns.owner.mu.Lock()
+	defer tg.pidns.owner.mu.Unlock()
+	tg.cgroup = cg
+}
+
+// ThreadGroupsInCgroups returns the thread groups whose cgroups satisfy in.
+// Thread groups whose tasks have all become zombies are excluded, as Linux
+// moves exiting tasks out of their cgroups.
+func (ts *TaskSet) ThreadGroupsInCgroups(in func(Cgroup) bool) []*ThreadGroup {
+	ts.mu.RLock()
+	defer ts.mu.RUnlock()
+	var tgs []*ThreadGroup
+	ts.forEachThreadGroupLocked(func(tg *ThreadGroup) {
+		if tg.liveTasks > 0 && in(tg.cgroup) {
+			tgs = append(tgs, tg)
+		}
+	})
+	return tgs
+}
+
+// CgroupUsage is the resource usage of the thread groups in a set of cgroups.
+type CgroupUsage struct {
+	// CPU is the CPU usage of the live thread groups. Unlike Linux, it
+	// doesn't include the usage of the processes that have exited.
+	CPU usage.CPUStats
+
+	// RSS is the sum of the resident set sizes of the thread groups.
+	RSS uint64
+
+	// Tasks is the number of tasks that haven't become zombies.
+	Tasks uint64
+}
+
+// CgroupUsage returns the resource usage of the thread groups whose cgroups
+// satisfy in.
+func (ts *TaskSet) CgroupUsage(in func(Cgroup) bool) CgroupUsage {
+	ts.mu.RLock()
+	defer ts.mu.RUnlock()
+
+	var u CgroupUsage
+	var now uint64
+	ts.forEachThreadGroupLocked(func(tg *ThreadGroup) {
+		if tg.liveTasks == 0 || !in(tg.cgroup) {
+			return
+		}
+		if now == 0 {
+			now = tg.leader.k.CPUClockNow()
+		}
+		u.CPU.Accumulate(tg.cpuStatsAtLocked(now))
+		u.Tasks += uint64(tg.liveTasks)
+		tg.leader.WithMuLocked(func(t *Task) {
+			if mm := t.MemoryManager(); mm != nil {
+				u.RSS += mm.ResidentSetSize()
+			}
+		})
+	})
+	return u
+}
@@ -0,0 +1,97 @@
+// Copyright 2020 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kernel
+
+import (
+	"gvisor.dev/gvisor/pkg/sentry/usage"
+)
+
+// ContainerUsage is the resource usage of the processes of a container.
+//
+// +stateify savable
+type ContainerUsage struct {
+	// CPU is the CPU usage of the container's live and exited processes.
+	CPU usage.CPUStats
+
+	// IO is the I/O usage of the container's live and exited processes.
+	IO usage.IO
+
+	// RSS is the sum of the resident set sizes of the container's live
+	// processes. Memory shared between processes is counted once per process,
+	// as in Linux's /proc/[pid]/stat.
+	RSS uint64
+
+	// Processes is the number of live processes in the container.
+	Processes uint64
+}
+
+// ContainerUsage returns the resource usage of the processes of the container
+// with the given ID, including those that have exited.
+func (ts *TaskSet) ContainerUsage(cid string) ContainerUsage {
+	ts.mu.RLock()
+	defer ts.mu.RUnlock()
+
+	var u ContainerUsage
+	if exited, ok := ts.exitedContainerUsage[cid]; ok {
+		u.CPU = exited.CPU
+		u.IO.Accumulate(&exited.IO)
+	}
+	var now uint64
+	ts.forEachThreadGroupLocked(func(tg *ThreadGroup) {
+		if tg.leader == nil || tg.leader.containerID != cid {
+			return
+		}
+		if now == 0 {
+			now = tg.leader.k.CPUClockNow()
+		}
+		u.Processes++
+		u.CPU.Accumulate(tg.cpuStatsAtLocked(now))
+		u.IO.Accumulate(tg.ioUsage)
+		for t := tg.tasks.Front(); t != nil; t = t.Next() {
+			u.IO.Accumulate(t.ioUsage)
+		}
+		tg.leader.WithMuLocked(func(t *Task) {
+			if mm := t.MemoryManager(); mm != nil {
+				u.RSS += mm.ResidentSetSize()
+			}
+		})
+	})
+	return u
+}
+
+// ForgetContainerUsage discards the usage of the exited processes of the
+// container with the given ID, after the container is destroyed.
+func (ts *TaskSet) ForgetContainerUsage(cid string) {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	delete(ts.exitedContainerUsage, cid)
+}
+
+// accountExitedThreadGroupLocked adds the usage of tg, whose tasks have all
+// exited, to the usage of the container with the given ID.
+//
+// Preconditions: ts.mu must be locked for writing.
+func (ts *TaskSet) accountExitedThreadGroupLocked(cid string, tg *ThreadGroup) {
+	if ts.exitedContainerUsage == nil {
+		ts.exitedContainerUsage = make(map[string]*ContainerUsage)
+	}
+	u, ok := ts.exitedContainerUsage[cid]
+	if !ok {
+		u = &ContainerUsage{}
+		ts.exitedContainerUsage[cid] = u
+	}
+	u.CPU.Accumulate(tg.exitedCPUStats)
+	u.IO.Accumulate(tg.ioUsage)
+}
@@ -0,0 +1,76 @@
+// Copyright 2020 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kernel
+
+import (
+	"testing"
+	"time"
+
+	"gvisor.dev/gvisor/pkg/sentry/usage"
+)
+
+// exitedThreadGroup returns a thread group whose tasks have all exited with
+// the given usage.
+func exitedThreadGroup(cpu usage.CPUStats, io usage.IO) *ThreadGroup {
+	return &ThreadGroup{
+		exitedCPUStats: cpu,
+		ioUsage:        &io,
+	}
+}
+
+func TestExitedContainerUsage(t *testing.T) {
+	ts := newTaskSet(NewRootPIDNamespace(nil))
+
+	ts.mu.Lock()
+	ts.accountExitedThreadGroupLocked("a", exitedThreadGroup(
+		usage.CPUStats{UserTime: time.Second, SysTime: time.Millisecond, VoluntarySwitches: 1},
+		usage.IO{CharsRead: 10, ReadSyscalls: 1}))
+	ts.accountExitedThreadGroupLocked("a", exitedThreadGroup(
+		usage.CPUStats{UserTime: 2 * time.Second, VoluntarySwitches: 2},
+		usage.IO{CharsWritten: 20, WriteSyscalls: 2}))
+	ts.accountExitedThreadGroupLocked("b", exitedThreadGroup(
+		usage.CPUStats{SysTime: time.Second},
+		usage.IO{BytesRead: 4096}))
+	ts.mu.Unlock()
+
+	// The usage of exited thread groups is kept per container, and no
+	// process is live.
+	want := ContainerUsage{
+		CPU: usage.CPUStats{UserTime: 3 * time.Second, SysTime: time.Millisecond, VoluntarySwitches: 3},
+		IO:  usage.IO{CharsRead: 10, CharsWritten: 20, ReadSyscalls: 1, WriteSyscalls: 2},
+	}
+	if got := ts.ContainerUsage("a"); got != want {
+		t.Errorf("ContainerUsage(a) got %+v, want %+v", got, want)
+	}
+	want = ContainerUsage{
+		CPU: usage.CPUStats{SysTime: time.Second},
+		IO:  usage.IO{BytesRead: 4096},
+	}
+	if got := ts.ContainerUsage("b"); got != want {
+		t.Errorf("ContainerUsage(b) got %+v, want %+v", got, want)
+	}
+	if got := ts.ContainerUsage("c"); got != (ContainerUsage{}) {
+		t.Errorf("ContainerUsage(c) got %+v, want no usage", got)
+	}
+
+	// Forgetting a container doesn't affect the others.
+	ts.ForgetContainerUsage("a")
+	if got := ts.ContainerUsage("a"); got != (ContainerUsage{}) {
+		t.Errorf("ContainerUsage(a) after ForgetContainerUsage got %+v, want no usage", got)
+	}
+	if got := ts.ContainerUsage("b"); got != want {
+		t.Errorf("ContainerUsage(b) after ForgetContainerUsage(a) got %+v, want %+v", got, want)
+	}
+}
@@ -45,6 +45,7 @@ import (
 	"gvisor.dev/gvisor/pkg/eventchannel"
 	"gvisor.dev/gvisor/pkg/fspath"
 	"gvisor.dev/gvisor/pkg/log"
+	"gvisor.dev/gvisor/pkg/otlp"
 	"gvisor.dev/gvisor/pkg/refs"
 	"gvisor.dev/gvisor/pkg/sentry/arch"
 	"gvisor.dev/gvisor/pkg/sentry/fs"
@@ -115,6 +116,18 @@ type Kernel struct {
 	// mf provides application memory.
 	mf *pgalloc.MemoryFile `state:"nosave"`
 
+	// spillMF, if not nil, provides memory backed by host storage. See
+	// pgalloc.SpillMemoryFileProvider.
+	spillMF *pgalloc.MemoryFile `state:"nosave"`
+
+	// savedSpillMF is true if spillMF's state follows mf's in the saved state
+	// file. It is set by SaveTo.
+	savedSpillMF bool
+
+	// dirtyTracking is true if StartDirtyTracking has been called. It is
+	// protected by extMu.
+	dirtyTracking bool `state:"nosave"`
+
 	// See InitKernelArgs for the meaning of these fields.
 	featureSet                  *cpuid.FeatureSet
 	timekeeper                  *Timekeeper
@@ -129,6 +142,12 @@ type Kernel struct {
 	rootIPCNamespace            *IPCNamespace
 	rootAbstractSocketNamespace *AbstractSocketNamespace
 
+	// onlineCores is the number of online CPUs; CPUs [0, onlineCores) are
+	// online, and the remaining CPUs in [0, applicationCores) are offline, as
+	// after CPU hot-unplug in Linux. onlineCores is accessed using atomic
+	// memory operations.
+	onlineCores uint32
+
 	// futexes is the "root" futex.Manager, from which all others are forked.
 	// This is necessary to ensure that shared futexes are coherent across all
 	// tasks, including those created by CreateProcess.
@@ -256,6 +275,10 @@ type Kernel struct {
 	// SpecialOpts contains special kernel options.
 	SpecialOpts
 
+	// cgroupRoot is the root of the emulated cgroup hierarchy, or nil if
+	// there is none. cgroupRoot is immutable after initialization.
+	cgroupRoot Cgroup
+
 	// vfs keeps the filesystem state used across the kernel.
 	vfs vfs.VirtualFilesystem
 
@@ -282,6 +305,29 @@ type Kernel struct {
 	// If set to true, report address space activation waits as if the task is in
 	// external wait so that the watchdog doesn't report the task stuck.
 	SleepForAddressSpaceActivation bool
+
+	// coreDumps configures the core dumps of crashing processes. It is set by
+	// SetCoreDumpOptions and immutable after the kernel is started.
+	coreDumps CoreDumpOptions `state:"nosave"`
+
+	// corePipeMu serializes writes to coreDumps.Pipe.
+	corePipeMu sync.Mutex `state:"nosave"`
+
+	// corePipeBroken is true if a core dump couldn't be written entirely to
+	// coreDumps.Pipe, such that the dumps that follow could not be delimited.
+	// It is protected by corePipeMu.
+	corePipeBroken bool `state:"nosave"`
+
+	// auditMu protects auditor.
+	auditMu sync.Mutex `state:"nosave"`
+
+	// auditor generates audit records for syscalls. It is set once by
+	// Auditor, and isn't saved since the audit configuration isn't.
+	auditor Auditor `state:"nosave"`
+
+	// auditEnabled is 1 if auditor is called for syscalls, and 0 otherwise.
+	// It is accessed using atomic memory operations.
+	auditEnabled uint32 `state:"nosave"`
 }
 
 // InitKernelArgs holds arguments to Init.
@@ -305,6 +351,11 @@ type InitKernelArgs struct {
 	// most significant bit in cpu_possible_mask + 1.
 	ApplicationCores uint
 
+	// OnlineCores is the number of CPUs that are initially online. If it is
+	// zero or greater than ApplicationCores, all CPUs are online. CPUs may be
+	// brought online or taken offline later by Kernel.SetOnlineCores.
+	OnlineCores uint
+
 	// If UseHostCores is true, Task.CPU() returns the task goroutine's CPU
 	// instead of a virtualized CPU number, and Task.CopyToCPUMask() is a
 	// no-op. If ApplicationCores is less than hostcpu.MaxPossibleCPU(), it
@@ -361,7 +412,7 @@ func (k *Kernel) Init(args InitKernelArgs) error {
 	k.rootAbstractSocketNamespace = args.RootAbstractSocketNamespace
 	k.rootNetworkNamespace = args.RootNetworkNamespace
 	if k.rootNetworkNamespace == nil {
-		k.rootNetworkNamespace = inet.NewRootNamespace(nil, nil)
+		k.rootNetworkNamespace = inet.NewRootNamespace(nil, nil, args.RootUserNamespace)
 	}
 	k.applicationCores = args.ApplicationCores
 	if args.UseHostCores {
@@ -376,6 +427,10 @@ func (k *Kernel) Init(args InitKernelArgs) error {
 			k.applicationCores = minAppCores
 		}
 	}
+	k.onlineCores = uint32(k.applicationCores)
+	if args.OnlineCores != 0 && args.OnlineCores < k.applicationCores && !k.useHostCores {
+		k.onlineCores = uint32(args.OnlineCores)
+	}
 	k.extraAuxv = args.ExtraAuxv
 	k.vdso = args.Vdso
 	k.realtimeClock = &timekeeperClock{tk: args.Timekeeper, c: sentrytime.Realtime}
@@ -433,6 +488,83 @@ func (k *Kernel) Init(args InitKernelArgs) error {
 //
 // Preconditions: The kernel must be paused throughout the call to SaveTo.
 func (k *Kernel) SaveTo(ctx context.Context, w wire.Writer) error {
+	return k.saveTo(ctx, w, false /* incremental */)
+}
+
+// SaveIncrementalTo is equivalent to SaveTo, except that only the application
+// memory that has changed since the last call to SaveMemoryIncrement is
+// saved. The result must be loaded by LoadIncrementalFrom, into a Kernel whose
+// application memory has been restored by LoadMemoryIncrement from every
+// preceding memory increment.
+//
+// Preconditions:
+// * The kernel must be paused throughout the call to SaveIncrementalTo.
+// * StartDirtyTracking must have been called.
+func (k *Kernel) SaveIncrementalTo(ctx context.Context, w wire.Writer) error {
+	return k.saveTo(ctx, w, true /* incremental */)
+}
+
+// dirtyLogger is implemented by platforms that can report writes to
+// application memory by application code.
+type dirtyLogger interface {
+	pgalloc.WriteTracker
+
+	// EnableDirtyLogging starts recording writes by application code.
+	EnableDirtyLogging() error
+}
+
+// StartDirtyTracking causes k to track writes to application memory, so that
+// it can be saved incrementally by SaveMemoryIncrement and
+// SaveIncrementalTo. This requires a platform that can report writes by
+// application code, such as KVM.
+func (k *Kernel) StartDirtyTracking() error {
+	k.extMu.Lock()
+	defer k.extMu.Unlock()
+	if k.dirtyTracking {
+		return nil
+	}
+	dl, ok := k.Platform.(dirtyLogger)
+	if !ok {
+		return fmt.Errorf("platform %T can't track writes to application memory", k.Platform)
+	}
+	// Writes to the spill memory file would need to be reported to both
+	// MemoryFiles, but the platform's log can only be collected once.
+	if k.spillMF != nil {
+		return fmt.Errorf("incremental save isn't supported with a spill memory file")
+	}
+	if err := dl.EnableDirtyLogging(); err != nil {
+		return err
+	}
+	k.mf.StartDirtyTracking(dl)
+	k.dirtyTracking = true
+	return nil
+}
+
+// SaveMemoryIncrement saves the application memory that has changed since the
+// last call to SaveMemoryIncrement to w, or all application memory if full is
+// true, while applications continue to run. It returns the number of bytes of
+// memory saved.
+//
+// Preconditions: StartDirtyTracking must have been called.
+func (k *Kernel) SaveMemoryIncrement(ctx context.Context, w wire.Writer, full bool) (uint64, error) {
+	return k.mf.SaveLiveTo(ctx, w, full)
+}
+
+// DirtyMemoryBytes returns the number of bytes of application memory that
+// have been written since the last call to SaveMemoryIncrement.
+//
+// Preconditions: StartDirtyTracking must have been called.
+func (k *Kernel) DirtyMemoryBytes() (uint64, error) {
+	return k.mf.DirtyBytes()
+}
+
+// LoadMemoryIncrement applies application memory saved by SaveMemoryIncrement
+// on the source Kernel to k. It must be called before LoadIncrementalFrom.
+func (k *Kernel) LoadMemoryIncrement(ctx context.Context, r wire.Reader) error {
+	return k.mf.LoadDirtyFrom(ctx, r)
+}
+
+func (k *Kernel) saveTo(ctx context.Context, w wire.Writer, incremental bool) error {
 	saveStart := time.Now()
 
 	// Do not allow other Kernel methods to affect it while it's being saved.
@@ -504,6 +636,7 @@ func (k *Kernel) SaveTo(ctx context.Context, w wire.Writer) error {
 	//
 	// N.B. This will also be saved along with the full kernel save below.
 	cpuidStart := time.Now()
+	otlp.Default().Record("checkpoint.prepare", saveStart, cpuidStart, nil)
 	if _, err := state.Save(ctx, w, k.FeatureSet()); err != nil {
 		return err
 	}
@@ -511,19 +644,31 @@ func (k *Kernel) SaveTo(ctx context.Context, w wire.Writer) error {
 
 	// Save the kernel state.
 	kernelStart := time.Now()
+	k.savedSpillMF = k.spillMF != nil
 	stats, err := state.Save(ctx, w, k)
 	if err != nil {
 		return err
 	}
 	log.Infof("Kernel save stats: %s", stats.String())
 	log.Infof("Kernel save took [%s].", time.Since(kernelStart))
+	otlp.Default().Record("checkpoint.kernel", kernelStart, time.Now(), nil)
 
 	// Save the memory file's state.
 	memoryStart := time.Now()
-	if err := k.mf.SaveTo(ctx, w); err != nil {
+	if incremental {
+		if err := k.mf.SaveDirtyTo(ctx, w); err != nil {
+			return err
+		}
+	} else if err := k.mf.SaveTo(ctx, w); err != nil {
 		return err
 	}
+	if k.spillMF != nil {
+		if err := k.spillMF.SaveTo(ctx, w); err != nil {
+			return err
+		}
+	}
 	log.Infof("Memory save took [%s].", time.Since(memoryStart))
+	otlp.Default().Record("checkpoint.memory", memoryStart, time.Now(), nil)
 
 	log.Infof("Overall save took [%s].", time.Since(saveStart))
 
@@ -654,9 +799,17 @@ func (k *Kernel) invalidateUnsavableMappings(ctx context.Context) error {
 
 // LoadFrom returns a new Kernel loaded from args.
 func (k *Kernel) LoadFrom(ctx context.Context, r wire.Reader, net inet.Stack, clocks sentrytime.Clocks, vfsOpts *vfs.CompleteRestoreOptions) error {
-	loadStart := time.Now()
+	return k.loadFrom(ctx, r, net, clocks, vfsOpts, false /* incremental */)
+}
 
-	initAppCores := k.applicationCores
+// LoadIncrementalFrom is equivalent to LoadFrom, but loads state saved by
+// SaveIncrementalTo.
+func (k *Kernel) LoadIncrementalFrom(ctx context.Context, r wire.Reader, net inet.Stack, clocks sentrytime.Clocks, vfsOpts *vfs.CompleteRestoreOptions) error {
+	return k.loadFrom(ctx, r, net, clocks, vfsOpts, true /* incremental */)
+}
+
+func (k *Kernel) loadFrom(ctx context.Context, r wire.Reader, net inet.Stack, clocks sentrytime.Clocks, vfsOpts *vfs.CompleteRestoreOptions, incremental bool) error {
+	loadStart := time.Now()
 
 	// Load the pre-saved CPUID FeatureSet.
 	//
@@ -685,6 +838,7 @@ func (k *Kernel) LoadFrom(ctx context.Context, r wire.Reader, net inet.Stack, cl
 	}
 	log.Infof("Kernel load stats: %s", stats.String())
 	log.Infof("Kernel load took [%s].", time.Since(kernelStart))
+	otlp.Default().Record("restore.kernel", kernelStart, time.Now(), nil)
 
 	// rootNetworkNamespace should be populated after loading the state file.
 	// Restore the root network stack.
@@ -692,10 +846,24 @@ func (k *Kernel) LoadFrom(ctx context.Context, r wire.Reader, net inet.Stack, cl
 
 	// Load the memory file's state.
 	memoryStart := time.Now()
-	if err := k.mf.LoadFrom(ctx, r); err != nil {
+	if incremental {
+		if err := k.mf.LoadDirtyFrom(ctx, r); err != nil {
+			return err
+		}
+	} else if err := k.mf.LoadFrom(ctx, r); err != nil {
 		return err
 	}
+	if k.savedSpillMF {
+		if k.spillMF == nil {
+			return fmt.Errorf("saved kernel has a spill memory file, but none was provided")
+		}
+		if err := k.spillMF.LoadFrom(ctx, r); err != nil {
+			return err
+		}
+	}
 	log.Infof("Memory load took [%s].", time.Since(memoryStart))
+	asyncStart := time.Now()
+	otlp.Default().Record("restore.memory", memoryStart, asyncStart, nil)
 
 	log.Infof("Overall load took [%s]", time.Since(loadStart))
 
@@ -720,15 +888,11 @@ func (k *Kernel) LoadFrom(ctx context.Context, r wire.Reader, net inet.Stack, cl
 	tcpip.AsyncLoading.Wait()
 
 	log.Infof("Overall load took [%s] after async work", time.Since(loadStart))
+	otlp.Default().Record("restore.async", asyncStart, time.Now(), nil)
 
-	// Applications may size per-cpu structures based on k.applicationCores, so
-	// it can't change across save/restore. When we are virtualizing CPU
-	// numbers, this isn't a problem. However, when we are exposing host CPU
-	// assignments, we can't tolerate an increase in the number of host CPUs,
-	// which could result in getcpu(2) returning CPUs that applications expect
-	// not to exist.
-	if k.useHostCores && initAppCores > k.applicationCores {
-		return fmt.Errorf("UseHostCores enabled: can't increase ApplicationCores from %d to %d after restore", k.applicationCores, initAppCores)
+	// Kernels saved before CPUs could be taken offline have all CPUs online.
+	if k.onlineCores == 0 {
+		k.onlineCores = uint32(k.applicationCores)
 	}
 
 	return nil
@@ -1444,6 +1608,62 @@ func (k *Kernel) ApplicationCores() uint {
 	return k.applicationCores
 }
 
+// OnlineCores returns the number of CPUs that are online. The online CPUs
+// are [0, OnlineCores()).
+func (k *Kernel) OnlineCores() uint {
+	return uint(atomic.LoadUint32(&k.onlineCores))
+}
+
+// SetOnlineCores brings CPUs online or takes them offline, such that CPUs
+// [0, n) are online. n must be between 1 and ApplicationCores(), so the
+// number of online CPUs can only grow if the Kernel was initialized with
+// fewer OnlineCores than ApplicationCores.
+func (k *Kernel) SetOnlineCores(n uint) error {
+	if k.useHostCores {
+		return fmt.Errorf("can't change online CPUs when host CPU numbers are used")
+	}
+	if n == 0 || n > k.applicationCores {
+		return fmt.Errorf("invalid number of online CPUs %d, must be between 1 and %d", n, k.applicationCores)
+	}
+	old := atomic.SwapUint32(&k.onlineCores, uint32(n))
+	log.Infof("Online CPUs changed from %d to %d", old, n)
+	return nil
+}
+
+// AdjustRestoredCores adapts a Kernel restored by LoadFrom to a host on which
+// n CPUs are available to the sandbox.
+//
+// Applications may size per-cpu structures based on ApplicationCores, so it
+// can't change across save/restore. Instead, CPUs [0, n) are brought online
+// and the rest are taken offline, as if by SetOnlineCores; if n exceeds
+// ApplicationCores, all CPUs are online. When we are exposing host CPU
+// assignments, we can't tolerate an increase in the number of host CPUs,
+// which could result in getcpu(2) returning CPUs that applications expect not
+// to exist.
+func (k *Kernel) AdjustRestoredCores(n uint) error {
+	if k.useHostCores {
+		maxCPU, err := hostcpu.MaxPossibleCPU()
+		if err != nil {
+			return fmt.Errorf("failed to get maximum CPU number: %v", err)
+		}
+		if hostCores := uint(maxCPU) + 1; hostCores > k.applicationCores {
+			return fmt.Errorf("UseHostCores enabled: can't increase ApplicationCores from %d to %d after restore", k.applicationCores, hostCores)
+		}
+		return nil
+	}
+	if n == 0 {
+		return fmt.Errorf("invalid number of CPUs 0")
+	}
+	if n > k.applicationCores {
+		log.Infof("Restored sandbox has %d possible CPUs, fewer than the %d available", k.applicationCores, n)
+		n = k.applicationCores
+	}
+	if old := atomic.SwapUint32(&k.onlineCores, uint32(n)); old != uint32(n) {
+		log.Infof("Online CPUs changed from %d when saved to %d", old, n)
+	}
+	return nil
+}
+
 // RealtimeClock returns the application CLOCK_REALTIME clock.
 func (k *Kernel) RealtimeClock() ktime.Clock {
 	return k.realtimeClock
@@ -1567,6 +1787,17 @@ func (k *Kernel) MemoryFile() *pgalloc.MemoryFile {
 	return k.mf
 }
 
+// SetSpillMemoryFile sets Kernel.spillMF. SetSpillMemoryFile must be called
+// before Init or LoadFrom.
+func (k *Kernel) SetSpillMemoryFile(mf *pgalloc.MemoryFile) {
+	k.spillMF = mf
+}
+
+// SpillMemoryFile implements pgalloc.SpillMemoryFileProvider.SpillMemoryFile.
+func (k *Kernel) SpillMemoryFile() *pgalloc.MemoryFile {
+	return k.spillMF
+}
+
 // SupervisorContext returns a Context with maximum privileges in k. It should
 // only be used by goroutines outside the control of the emulated kernel
 // defined by e.
@@ -0,0 +1,57 @@
+// Copyright 2021 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+
+package kernel
+
+import (
+	"testing"
+)
+
+func TestSetOnlineCores(t *testing.T) {
+	k := &Kernel{applicationCores: 4, onlineCores: 4}
+	for _, test := range []struct {
+		n       uint
+		wantErr bool
+	}{
+		{n: 1},
+		{n: 0, wantErr: true},
+		{n: 3},
+		// CPUs can't be brought online beyond the possible CPUs.
+		{n: 5, wantErr: true},
+		{n: 4},
+	} {
+		want := k.OnlineCores()
+		if !test.wantErr {
+			want = test.n
+		}
+		err := k.SetOnlineCores(test.n)
+		if gotErr := err != nil; gotErr != test.wantErr {
+			t.Errorf("SetOnlineCores(%d) got error %v, want error: %t", test.n, err, test.wantErr)
+		}
+		if got := k.OnlineCores(); got != want {
+			t.Errorf("after SetOnlineCores(%d), OnlineCores() got %d, want %d", test.n, got, want)
+		}
+	}
+}
+
+func TestSetOnlineCoresUseHostCores(t *testing.T) {
+	k := &Kernel{applicationCores: 4, onlineCores: 4, useHostCores: true}
+	if err := k.SetOnlineCores(2); err == nil {
+		t.Errorf("SetOnlineCores succeeded with host CPU numbers")
+	}
+	if got := k.OnlineCores(); got != 4 {
+		t.Errorf("OnlineCores() got %d, want 4", got)
+	}
+}
@@ -0,0 +1,301 @@
+// Copyright 2020 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kernel
+
+import (
+	"container/heap"
+	"fmt"
+	"sort"
+	"sync/atomic"
+	"time"
+
+	"gvisor.dev/gvisor/pkg/metric"
+	pb "gvisor.dev/gvisor/pkg/metric/metric_go_proto"
+	"gvisor.dev/gvisor/pkg/sentry/arch"
+	"gvisor.dev/gvisor/pkg/sync"
+)
+
+// syscallLatencyBounds are the upper bounds, in nanoseconds, of the buckets of
+// the per-syscall latency histograms.
+var syscallLatencyBounds = []int64{
+	int64(time.Microsecond),
+	int64(10 * time.Microsecond),
+	int64(100 * time.Microsecond),
+	int64(time.Millisecond),
+	int64(10 * time.Millisecond),
+	int64(100 * time.Millisecond),
+	int64(time.Second),
+}
+
+const (
+	// MaxSlowSyscalls is the number of slowest recent syscalls that are kept.
+	MaxSlowSyscalls = 64
+
+	// slowSyscallWindow is how recent a syscall must be to be reported by
+	// SlowSyscalls.
+	slowSyscallWindow = time.Minute
+)
+
+// syscallStats holds the statistics collected for a SyscallTable.
+type syscallStats struct {
+	// latency holds the latency histogram of each syscall, indexed by
+	// syscall number. It is immutable.
+	latency []*metric.DistributionMetric
+
+	// missingLatency is the latency histogram of syscalls that aren't in
+	// the table.
+	missingLatency *metric.DistributionMetric
+
+	slow slowSyscalls
+}
+
+// EnableSyscallStats enables the collection of per-syscall latency histograms
+// for the syscall tables of the host architecture, and of the slowest recent
+// syscalls. The histograms are metrics named /kernel/syscall_latency/<syscall>.
+// Enabling this comes at a CPU cost due to performing two clock reads per
+// syscall.
+//
+// Preconditions:
+// * No syscalls have been executed.
+// * Metrics have not been initialized.
+func EnableSyscallStats() error {
+	for _, s := range allSyscallTables {
+		// Tables of other architectures are never used, and their syscall
+		// names would collide with those of the host's tables.
+		if s.Arch != arch.Host || s.stats != nil {
+			continue
+		}
+		stats := &syscallStats{
+			latency: make([]*metric.DistributionMetric, len(s.lookup)),
+		}
+		for sysno, sc := range s.Table {
+			d, err := metric.NewDistributionMetric(fmt.Sprintf("/kernel/syscall_latency/%s", sc.Name), false /* sync */, pb.MetricMetadata_UNITS_NANOSECONDS, syscallLatencyBounds, fmt.Sprintf("Latency of %s syscalls, in nanoseconds.", sc.Name))
+			if err != nil {
+				return fmt.Errorf("creating latency metric for syscall %s: %w", sc.Name, err)
+			}
+			stats.latency[sysno] = d
+		}
+		d, err := metric.NewDistributionMetric("/kernel/syscall_latency/missing", false /* sync */, pb.MetricMetadata_UNITS_NANOSECONDS, syscallLatencyBounds, "Latency of unimplemented syscalls, in nanoseconds.")
+		if err != nil {
+			return fmt.Errorf("creating latency metric for missing syscalls: %w", err)
+		}
+		stats.missingLatency = d
+		s.stats = stats
+	}
+	return nil
+}
+
+// record records the completion of a syscall that began at start and took d.
+func (s *syscallStats) record(t *Task, sysno uintptr, args arch.SyscallArguments, rval uintptr, err error, start time.Time, d time.Duration) {
+	if sysno < uintptr(len(s.latency)) && s.latency[sysno] != nil {
+		s.latency[sysno].AddSample(int64(d))
+	} else {
+		s.missingLatency.AddSample(int64(d))
+	}
+	if !s.slow.isSlow(d) {
+		return
+	}
+	sc := SlowSyscall{
+		Start:    start,
+		Duration: d,
+		Sysno:    sysno,
+		Name:     t.SyscallTable().LookupName(sysno),
+		TID:      t.k.tasks.Root.IDOfTask(t),
+		Return:   rval,
+	}
+	for i := range sc.Args {
+		sc.Args[i] = args[i].Uint64()
+	}
+	if err != nil {
+		sc.Error = err.Error()
+	}
+	s.slow.add(sc)
+}
+
+// SlowSyscall describes a syscall reported by SlowSyscalls.
+type SlowSyscall struct {
+	// Start is when the syscall began.
+	Start time.Time
+
+	// Duration is how long the syscall took.
+	Duration time.Duration
+
+	// Sysno and Name identify the syscall.
+	Sysno uintptr
+	Name  string
+
+	// TID is the ID of the calling thread in the root PID namespace.
+	TID ThreadID
+
+	// Args are the raw syscall arguments.
+	Args [6]uint64
+
+	// Return is the raw return value, and Error is the error returned by the
+	// syscall, if any.
+	Return uintptr
+	Error  string
+}
+
+// SlowSyscalls returns up to n of the slowest syscalls of the last minute,
+// slowest first. Syscall statistics must have been enabled by
+// EnableSyscallStats.
+func (k *Kernel) SlowSyscalls(n int) []SlowSyscall {
+	var all []SlowSyscall
+	for _, s := range allSyscallTables {
+		if s.stats != nil {
+			all = append(all, s.stats.slow.recent(time.Now())...)
+		}
+	}
+	sort.Slice(all, func(i, j int) bool {
+		return all[i].Duration > all[j].Duration
+	})
+	if len(all) > n {
+		all = all[:n]
+	}
+	return all
+}
+
+// SyscallLatency is the latency histogram of a syscall, as reported by
+// SyscallLatencies.
+type SyscallLatency struct {
+	// Name is the name of the syscall.
+	Name string
+
+	// Bounds are the exclusive upper bounds of all but the last bucket.
+	Bounds []time.Duration
+
+	// Counts are the number of syscalls in each bucket.
+	Counts []uint64
+}
+
+// SyscallLatencies returns the latency histograms of all syscalls that have
+// been called, sorted by name. Syscall statistics must have been enabled by
+// EnableSyscallStats.
+func (k *Kernel) SyscallLatencies() []SyscallLatency {
+	bounds := make([]time.Duration, len(syscallLatencyBounds))
+	for i, b := range syscallLatencyBounds {
+		bounds[i] = time.Duration(b)
+	}
+	var lats []SyscallLatency
+	add := func(name string, d *metric.DistributionMetric) {
+		counts := d.BucketCounts()
+		for _, c := range counts {
+			if c != 0 {
+				lats = append(lats, SyscallLatency{Name: name, Bounds: bounds, Counts: counts})
+				return
+			}
+		}
+	}
+	for _, s := range allSyscallTables {
+		if s.stats == nil {
+			continue
+		}
+		for sysno, d := range s.stats.latency {
+			if d != nil {
+				add(s.Table[uintptr(sysno)].Name, d)
+			}
+		}
+		add("missing", s.stats.missingLatency)
+	}
+	sort.Slice(lats, func(i, j int) bool {
+		return lats[i].Name < lats[j].Name
+	})
+	return lats
+}
+
+// slowSyscalls keeps the slowest syscalls of the current and previous
+// windows, each of which lasts slowSyscallWindow.
+type slowSyscalls struct {
+	// minNS is the duration, in nanoseconds, of the fastest syscall in cur if
+	// cur is full, or zero otherwise. It is accessed atomically so that
+	// syscalls that are too fast to be kept don't take mu.
+	minNS int64
+
+	mu sync.Mutex
+
+	// curStart is when the current window began.
+	curStart time.Time
+
+	// cur and prev hold the slowest syscalls of the current and previous
+	// windows.
+	cur  slowSyscallHeap
+	prev slowSyscallHeap
+}
+
+// isSlow returns true if a syscall that took d may be one of the slowest of
+// the current window.
+func (s *slowSyscalls) isSlow(d time.Duration) bool {
+	return int64(d) > atomic.LoadInt64(&s.minNS)
+}
+
+func (s *slowSyscalls) add(sc SlowSyscall) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if sc.Start.Sub(s.curStart) >= slowSyscallWindow {
+		s.prev = s.cur
+		s.cur = nil
+		s.curStart = sc.Start
+	}
+	if len(s.cur) < MaxSlowSyscalls {
+		heap.Push(&s.cur, sc)
+	} else if sc.Duration > s.cur[0].Duration {
+		s.cur[0] = sc
+		heap.Fix(&s.cur, 0)
+	}
+	var minNS int64
+	if len(s.cur) == MaxSlowSyscalls {
+		minNS = int64(s.cur[0].Duration)
+	}
+	atomic.StoreInt64(&s.minNS, minNS)
+}
+
+// recent returns the syscalls that began within slowSyscallWindow of now.
+func (s *slowSyscalls) recent(now time.Time) []SlowSyscall {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var scs []SlowSyscall
+	for _, h := range []slowSyscallHeap{s.prev, s.cur} {
+		for _, sc := range h {
+			if now.Sub(sc.Start) < slowSyscallWindow {
+				scs = append(scs, sc)
+			}
+		}
+	}
+	return scs
+}
+
+// slowSyscallHeap is a min-heap of syscalls ordered by duration.
+type slowSyscallHeap []SlowSyscall
+
+// Len implements sort.Interface.Len.
+func (h slowSyscallHeap) Len() int { return len(h) }
+
+// Less implements sort.Interface.Less.
+func (h slowSyscallHeap) Less(i, j int) bool { return h[i].Duration < h[j].Duration }
+
+// Swap implements sort.Interface.Swap.
+func (h slowSyscallHeap) Swap(i, j int) { h[i], h[j] = h[j], h[i] }
+
+// Push implements heap.Interface.Push.
+func (h *slowSyscallHeap) Push(x interface{}) { *h = append(*h, x.(SlowSyscall)) }
+
+// Pop implements heap.Interface.Pop.
+func (h *slowSyscallHeap) Pop() interface{} {
+	old := *h
+	n := len(old)
+	x := old[n-1]
+	*h = old[:n-1]
+	return x
+}
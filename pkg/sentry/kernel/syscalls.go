@@ -264,6 +264,10 @@ type SyscallTable struct {
 
 	// FeatureEnable stores the strace and one-shot enable bits.
 	FeatureEnable SyscallFlagsTable
+
+	// stats holds syscall statistics, or is nil if they aren't collected.
+	// It is set by EnableSyscallStats.
+	stats *syscallStats
 }
 
 // MaxSysno returns the largest system call number.
@@ -214,7 +214,7 @@ func (t *Task) Clone(opts *CloneOptions) (ThreadID, *SyscallControl, error) {
 
 	netns := t.NetworkNamespace()
 	if opts.NewNetworkNamespace {
-		netns = inet.NewNamespace(netns)
+		netns = inet.NewNamespace(netns, userns)
 	}
 
 	// TODO(b/63601033): Implement CLONE_NEWNS.
@@ -280,6 +280,7 @@ func (t *Task) Clone(opts *CloneOptions) (ThreadID, *SyscallControl, error) {
 		}
 		tg = t.k.NewThreadGroup(tg.mounts, pidns, sh, opts.TerminationSignal, tg.limits.GetCopy())
 		tg.oomScoreAdj = atomic.LoadInt32(&t.tg.oomScoreAdj)
+		tg.cgroup = t.tg.Cgroup()
 		rseqAddr = t.rseqAddr
 		rseqSignature = t.rseqSignature
 	}
@@ -504,7 +505,7 @@ func (t *Task) Unshare(opts *SharingOptions) error {
 			t.mu.Unlock()
 			return syserror.EPERM
 		}
-		t.netns = inet.NewNamespace(t.netns)
+		t.netns = inet.NewNamespace(t.netns, t.UserNamespace())
 	}
 	if opts.NewUTSNamespace {
 		if !haveCapSysAdmin {
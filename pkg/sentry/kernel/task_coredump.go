@@ -0,0 +1,607 @@
+// Copyright 2020 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kernel
+
+import (
+	"bytes"
+	"debug/elf"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"gvisor.dev/gvisor/pkg/abi/linux"
+	"gvisor.dev/gvisor/pkg/fspath"
+	"gvisor.dev/gvisor/pkg/sentry/arch"
+	"gvisor.dev/gvisor/pkg/sentry/kernel/auth"
+	"gvisor.dev/gvisor/pkg/sentry/limits"
+	"gvisor.dev/gvisor/pkg/sentry/mm"
+	"gvisor.dev/gvisor/pkg/sentry/vfs"
+	"gvisor.dev/gvisor/pkg/usermem"
+)
+
+// CoreDumpOptions configures the core dumps of crashing processes.
+type CoreDumpOptions struct {
+	// Pattern is the pattern of the names of core dump files, analogous to
+	// Linux's /proc/sys/kernel/core_pattern. If it is empty, core dumps are
+	// disabled. If it begins with '|', core dumps are written to Pipe
+	// instead of files.
+	//
+	// Files are created relative to the working directory of the crashing
+	// process, and the following specifiers are expanded in their names: %p
+	// and %i (the PID and TID of the crashing thread in its PID namespace),
+	// %P and %I (the same in the root PID namespace), %u and %g (its real
+	// UID and GID), %s (the signal that caused the dump), %t (the time of
+	// the dump, in seconds since the epoch), %h (the hostname), %e (the name
+	// of the thread) and %%.
+	Pattern string
+
+	// Pipe receives the core dumps of Pattern "|...". Each core dump is
+	// preceded by a single-line header that describes it, ending with the
+	// size of the core dump that follows in bytes:
+	//
+	//   core pid=1 tid=1 uid=0 gid=0 signal=11 time=1600000000 hostname="h" exe="a.out" size=4096
+	//
+	// The core dumps of different processes are never interleaved.
+	Pipe io.Writer
+}
+
+// SetCoreDumpOptions configures the core dumps of crashing processes. Core
+// dumps are disabled by default.
+//
+// Preconditions: The kernel must not have been started.
+func (k *Kernel) SetCoreDumpOptions(opts CoreDumpOptions) {
+	k.coreDumps = opts
+}
+
+// CoreDumpOptions returns the options set by SetCoreDumpOptions.
+func (k *Kernel) CoreDumpOptions() CoreDumpOptions {
+	return k.coreDumps
+}
+
+const (
+	// coreNoteName is the name of the notes of core dumps.
+	coreNoteName = "CORE"
+
+	// coreChunkSize is the number of bytes of memory that are copied into a
+	// core dump at a time.
+	coreChunkSize = 64 * usermem.PageSize
+
+	// psargsLen is the size of elf_prpsinfo.pr_psargs.
+	psargsLen = 80
+)
+
+// errCoreLimit is returned by coreWriter.Write when RLIMIT_CORE is exceeded.
+var errCoreLimit = errors.New("RLIMIT_CORE exceeded")
+
+// dumpCore writes a core dump of t's thread group for the signal described by
+// info, as in Linux's fs/coredump.c:do_coredump(). It returns true if the core
+// dump was written, including if it was truncated by RLIMIT_CORE.
+//
+// Unlike Linux, other threads in the thread group aren't stopped while their
+// memory is dumped, and only the registers of t are included.
+//
+// Preconditions: The caller must be running on the task goroutine.
+func (t *Task) dumpCore(info *arch.SignalInfo) bool {
+	opts := t.k.coreDumps
+	if opts.Pattern == "" {
+		return false
+	}
+	m := t.MemoryManager()
+	if m == nil || m.Dumpability() == mm.NotDumpable {
+		return false
+	}
+	limit := t.ThreadGroup().Limits().Get(limits.Core).Cur
+
+	c, err := t.newCoreDump(info)
+	if err != nil {
+		t.Warningf("Failed to prepare core dump: %v", err)
+		return false
+	}
+
+	if strings.HasPrefix(opts.Pattern, "|") {
+		// As in Linux, RLIMIT_CORE doesn't apply to piped core dumps, except
+		// that a limit of 1 disables them.
+		if opts.Pipe == nil || limit == 1 {
+			return false
+		}
+		return t.pipeCore(c, info)
+	}
+
+	if !VFS2Enabled || limit < usermem.PageSize {
+		return false
+	}
+	name := expandCorePattern(opts.Pattern, t.coreNameInfo(info))
+	switch err := t.writeCoreFile(name, c, limit); err {
+	case nil:
+		t.Infof("Wrote core dump to %q", name)
+	case errCoreLimit:
+		// As in Linux, a truncated core dump is still a core dump.
+		t.Infof("Wrote core dump to %q, truncated to %d bytes", name, limit)
+	default:
+		t.Infof("Failed to write core dump to %q: %v", name, err)
+		return false
+	}
+	return true
+}
+
+// pipeCore writes the core dump c to CoreDumpOptions.Pipe.
+func (t *Task) pipeCore(c *coreDump, info *arch.SignalInfo) bool {
+	k := t.k
+	k.corePipeMu.Lock()
+	defer k.corePipeMu.Unlock()
+	if k.corePipeBroken {
+		return false
+	}
+	creds := t.Credentials()
+	sec, _ := t.Kernel().RealtimeClock().Now().Unix()
+	if _, err := fmt.Fprintf(k.coreDumps.Pipe, "core pid=%d tid=%d uid=%d gid=%d signal=%d time=%d hostname=%q exe=%q size=%d\n",
+		t.TGIDInRoot(), t.k.tasks.Root.IDOfTask(t), creds.RealKUID, creds.RealKGID, info.Signo, sec,
+		t.UTSNamespace().HostName(), t.Name(), c.size()); err != nil {
+		t.Warningf("Failed to write core dump header: %v", err)
+		k.corePipeBroken = true
+		return false
+	}
+	if err := c.writeTo(t, &coreWriter{w: k.coreDumps.Pipe, limit: limits.Infinity}); err != nil {
+		t.Warningf("Failed to write core dump, no more core dumps will be written: %v", err)
+		k.corePipeBroken = true
+		return false
+	}
+	return true
+}
+
+// writeCoreFile writes the core dump c to the file at path name, at most limit
+// bytes of it.
+func (t *Task) writeCoreFile(name string, c *coreDump, limit uint64) error {
+	pop := vfs.PathOperation{
+		Root:  t.FSContext().RootDirectoryVFS2(),
+		Start: t.FSContext().WorkingDirectoryVFS2(),
+		Path:  fspath.Parse(name),
+	}
+	defer pop.Root.DecRef(t)
+	defer pop.Start.DecRef(t)
+	fd, err := t.Kernel().VFS().OpenAt(t, t.Credentials(), &pop, &vfs.OpenOptions{
+		Flags: linux.O_CREAT | linux.O_WRONLY | linux.O_TRUNC | linux.O_NOFOLLOW | linux.O_LARGEFILE,
+		Mode:  0600,
+	})
+	if err != nil {
+		return err
+	}
+	defer fd.DecRef(t)
+
+	// As in Linux, don't write to files that may be seen through other
+	// paths.
+	stat, err := fd.Stat(t, vfs.StatOptions{Mask: linux.STATX_TYPE | linux.STATX_NLINK})
+	if err != nil {
+		return err
+	}
+	if linux.FileMode(stat.Mode).FileType() != linux.ModeRegular || stat.Nlink != 1 {
+		return fmt.Errorf("not a regular file with a single link")
+	}
+	return c.writeTo(t, &coreWriter{w: &fileWriter{t: t, fd: fd}, limit: limit})
+}
+
+// coreNameInfo holds the values of the specifiers of CoreDumpOptions.Pattern.
+type coreNameInfo struct {
+	pid      ThreadID
+	rootPID  ThreadID
+	tid      ThreadID
+	rootTID  ThreadID
+	uid      auth.UID
+	gid      auth.GID
+	signo    int32
+	time     int64
+	hostname string
+	name     string
+}
+
+// coreNameInfo returns the coreNameInfo of a core dump of t for the signal
+// described by info.
+func (t *Task) coreNameInfo(info *arch.SignalInfo) coreNameInfo {
+	creds := t.Credentials()
+	sec, _ := t.Kernel().RealtimeClock().Now().Unix()
+	return coreNameInfo{
+		pid:      t.tg.pidns.IDOfThreadGroup(t.tg),
+		rootPID:  t.TGIDInRoot(),
+		tid:      t.ThreadID(),
+		rootTID:  t.k.tasks.Root.IDOfTask(t),
+		uid:      creds.RealKUID.In(creds.UserNamespace).OrOverflow(),
+		gid:      creds.RealKGID.In(creds.UserNamespace).OrOverflow(),
+		signo:    info.Signo,
+		time:     sec,
+		hostname: t.UTSNamespace().HostName(),
+		name:     t.Name(),
+	}
+}
+
+// expandCorePattern returns the name of a core dump file described by ci,
+// given by pattern. See CoreDumpOptions.Pattern.
+func expandCorePattern(pattern string, ci coreNameInfo) string {
+	// As in Linux's fs/coredump.c:cn_esc_printf(), slashes in names are
+	// replaced so that they don't create path components.
+	escape := func(s string) string {
+		return strings.Replace(s, "/", "!", -1)
+	}
+	var b strings.Builder
+	for i := 0; i < len(pattern); i++ {
+		if pattern[i] != '%' || i+1 == len(pattern) {
+			b.WriteByte(pattern[i])
+			continue
+		}
+		i++
+		switch pattern[i] {
+		case '%':
+			b.WriteByte('%')
+		case 'p':
+			fmt.Fprintf(&b, "%d", ci.pid)
+		case 'P':
+			fmt.Fprintf(&b, "%d", ci.rootPID)
+		case 'i':
+			fmt.Fprintf(&b, "%d", ci.tid)
+		case 'I':
+			fmt.Fprintf(&b, "%d", ci.rootTID)
+		case 'u':
+			fmt.Fprintf(&b, "%d", ci.uid)
+		case 'g':
+			fmt.Fprintf(&b, "%d", ci.gid)
+		case 's':
+			fmt.Fprintf(&b, "%d", ci.signo)
+		case 't':
+			fmt.Fprintf(&b, "%d", ci.time)
+		case 'h':
+			b.WriteString(escape(ci.hostname))
+		case 'e':
+			b.WriteString(escape(ci.name))
+		default:
+			// As in Linux, unknown specifiers are dropped.
+		}
+	}
+	return b.String()
+}
+
+// coreDump is an ELF core file, as in Linux's fs/binfmt_elf.c:elf_core_dump().
+type coreDump struct {
+	// headers are the ELF header, program headers and notes of the core
+	// file, which precede the contents of regions at dataOff.
+	headers []byte
+
+	// dataOff is the offset of the contents of regions in the file.
+	dataOff uint64
+
+	// regions are the regions of the address space of the crashing process.
+	regions []mm.CoreRegion
+}
+
+// elfPrstatus is struct elf_prstatus, without the registers and the
+// pr_fpvalid field that follow it.
+type elfPrstatus struct {
+	Signo   int32
+	Code    int32
+	Errno   int32
+	Cursig  int16
+	_       int16
+	Sigpend uint64
+	Sighold uint64
+	PID     int32
+	PPID    int32
+	PGRP    int32
+	SID     int32
+	Utime   linux.Timeval
+	Stime   linux.Timeval
+	Cutime  linux.Timeval
+	Cstime  linux.Timeval
+}
+
+// elfPrpsinfo is struct elf_prpsinfo.
+type elfPrpsinfo struct {
+	State  int8
+	Sname  uint8
+	Zomb   int8
+	Nice   int8
+	_      uint32
+	Flag   uint64
+	UID    uint32
+	GID    uint32
+	PID    int32
+	PPID   int32
+	PGRP   int32
+	SID    int32
+	Fname  [16]byte
+	Psargs [psargsLen]byte
+}
+
+// newCoreDump returns the coreDump of t's thread group for the signal
+// described by info.
+func (t *Task) newCoreDump(info *arch.SignalInfo) (*coreDump, error) {
+	var machine elf.Machine
+	switch t.Arch().Arch() {
+	case arch.AMD64:
+		machine = elf.EM_X86_64
+	case arch.ARM64:
+		machine = elf.EM_AARCH64
+	default:
+		return nil, fmt.Errorf("unsupported architecture %v", t.Arch().Arch())
+	}
+
+	var notes bytes.Buffer
+	if err := t.writeCoreNotes(&notes, info); err != nil {
+		return nil, err
+	}
+
+	c := &coreDump{
+		regions: t.MemoryManager().CoreRegions(),
+	}
+	hdrSize := uint64(binary.Size(elf.Header64{}))
+	phdrSize := uint64(binary.Size(elf.Prog64{}))
+	notesOff := hdrSize + phdrSize*uint64(1+len(c.regions))
+	c.dataOff = uint64(usermem.Addr(notesOff + uint64(notes.Len())).MustRoundUp())
+
+	var b bytes.Buffer
+	hdr := elf.Header64{
+		Type:      uint16(elf.ET_CORE),
+		Machine:   uint16(machine),
+		Version:   uint32(elf.EV_CURRENT),
+		Phoff:     hdrSize,
+		Ehsize:    uint16(hdrSize),
+		Phentsize: uint16(phdrSize),
+		Phnum:     uint16(1 + len(c.regions)),
+	}
+	copy(hdr.Ident[:], elf.ELFMAG)
+	hdr.Ident[elf.EI_CLASS] = byte(elf.ELFCLASS64)
+	hdr.Ident[elf.EI_DATA] = byte(elf.ELFDATA2LSB)
+	hdr.Ident[elf.EI_VERSION] = byte(elf.EV_CURRENT)
+	binary.Write(&b, usermem.ByteOrder, &hdr)
+
+	binary.Write(&b, usermem.ByteOrder, &elf.Prog64{
+		Type:   uint32(elf.PT_NOTE),
+		Off:    notesOff,
+		Filesz: uint64(notes.Len()),
+	})
+	off := c.dataOff
+	for _, r := range c.regions {
+		var flags elf.ProgFlag
+		if r.Perms.Read {
+			flags |= elf.PF_R
+		}
+		if r.Perms.Write {
+			flags |= elf.PF_W
+		}
+		if r.Perms.Execute {
+			flags |= elf.PF_X
+		}
+		var filesz uint64
+		if r.Dump {
+			filesz = r.Range.Length()
+		}
+		binary.Write(&b, usermem.ByteOrder, &elf.Prog64{
+			Type:   uint32(elf.PT_LOAD),
+			Flags:  uint32(flags),
+			Off:    off,
+			Vaddr:  uint64(r.Range.Start),
+			Filesz: filesz,
+			Memsz:  r.Range.Length(),
+			Align:  usermem.PageSize,
+		})
+		off += filesz
+	}
+	b.Write(notes.Bytes())
+	c.headers = b.Bytes()
+	return c, nil
+}
+
+// writeCoreNotes writes the notes of a core dump of t's thread group to b.
+func (t *Task) writeCoreNotes(b *bytes.Buffer, info *arch.SignalInfo) error {
+	pidns := t.tg.pidns
+	var ppid ThreadID
+	if parent := t.Parent(); parent != nil {
+		ppid = pidns.IDOfThreadGroup(parent.tg)
+	}
+	var pgid ProcessGroupID
+	if pg := t.tg.ProcessGroup(); pg != nil {
+		pgid = pidns.IDOfProcessGroup(pg)
+	}
+	var sid SessionID
+	if s := t.tg.Session(); s != nil {
+		sid = pidns.IDOfSession(s)
+	}
+
+	// NT_PRSTATUS.
+	stats := t.CPUStats()
+	childStats := t.tg.JoinedChildCPUStats()
+	var prstatus bytes.Buffer
+	binary.Write(&prstatus, usermem.ByteOrder, &elfPrstatus{
+		Signo:   info.Signo,
+		Code:    info.Code,
+		Errno:   info.Errno,
+		Cursig:  int16(info.Signo),
+		Sigpend: uint64(t.PendingSignals()),
+		Sighold: uint64(t.SignalMask()),
+		PID:     int32(pidns.IDOfTask(t)),
+		PPID:    int32(ppid),
+		PGRP:    int32(pgid),
+		SID:     int32(sid),
+		Utime:   linux.DurationToTimeval(stats.UserTime),
+		Stime:   linux.DurationToTimeval(stats.SysTime),
+		Cutime:  linux.DurationToTimeval(childStats.UserTime),
+		Cstime:  linux.DurationToTimeval(childStats.SysTime),
+	})
+	if _, err := t.Arch().PtraceGetRegs(&prstatus); err != nil {
+		return fmt.Errorf("getting registers: %v", err)
+	}
+	var fpregs bytes.Buffer
+	if _, err := t.Arch().PtraceGetFPRegs(&fpregs); err != nil {
+		return fmt.Errorf("getting floating point registers: %v", err)
+	}
+	// pr_fpvalid, followed by padding.
+	var fpvalid uint64
+	if fpregs.Len() != 0 {
+		fpvalid = 1
+	}
+	binary.Write(&prstatus, usermem.ByteOrder, fpvalid)
+	writeCoreNote(b, linux.NT_PRSTATUS, prstatus.Bytes())
+
+	// NT_PRPSINFO.
+	creds := t.Credentials()
+	psinfo := elfPrpsinfo{
+		Sname: 'R',
+		Nice:  int8(t.Niceness()),
+		UID:   uint32(creds.RealKUID.In(creds.UserNamespace).OrOverflow()),
+		GID:   uint32(creds.RealKGID.In(creds.UserNamespace).OrOverflow()),
+		PID:   int32(pidns.IDOfThreadGroup(t.tg)),
+		PPID:  int32(ppid),
+		PGRP:  int32(pgid),
+		SID:   int32(sid),
+	}
+	copy(psinfo.Fname[:len(psinfo.Fname)-1], t.Name())
+	m := t.MemoryManager()
+	if argv, ok := m.ArgvStart().ToRange(uint64(m.ArgvEnd() - m.ArgvStart())); ok && argv.Length() != 0 {
+		n := argv.Length()
+		if n > psargsLen-1 {
+			n = psargsLen - 1
+		}
+		n32, _ := m.CopyIn(t, argv.Start, psinfo.Psargs[:n], usermem.IOOpts{IgnorePermissions: true})
+		// As in Linux, arguments are separated by spaces.
+		for i := 0; i < n32; i++ {
+			if psinfo.Psargs[i] == 0 {
+				psinfo.Psargs[i] = ' '
+			}
+		}
+	}
+	var prpsinfo bytes.Buffer
+	binary.Write(&prpsinfo, usermem.ByteOrder, &psinfo)
+	writeCoreNote(b, linux.NT_PRPSINFO, prpsinfo.Bytes())
+
+	// NT_AUXV.
+	var auxv bytes.Buffer
+	for _, e := range m.Auxv() {
+		binary.Write(&auxv, usermem.ByteOrder, [2]uint64{e.Key, uint64(e.Value)})
+	}
+	binary.Write(&auxv, usermem.ByteOrder, [2]uint64{linux.AT_NULL, 0})
+	writeCoreNote(b, linux.NT_AUXV, auxv.Bytes())
+
+	// NT_PRFPREG.
+	if fpregs.Len() != 0 {
+		writeCoreNote(b, linux.NT_PRFPREG, fpregs.Bytes())
+	}
+	return nil
+}
+
+// writeCoreNote writes an ELF note of type typ named coreNoteName, with
+// contents desc, to b.
+func writeCoreNote(b *bytes.Buffer, typ uint32, desc []byte) {
+	binary.Write(b, usermem.ByteOrder, [3]uint32{uint32(len(coreNoteName) + 1), uint32(len(desc)), typ})
+	b.WriteString(coreNoteName)
+	b.WriteByte(0)
+	writeCorePadding(b)
+	b.Write(desc)
+	writeCorePadding(b)
+}
+
+// writeCorePadding pads b to a multiple of 4 bytes, the alignment of note
+// names and contents.
+func writeCorePadding(b *bytes.Buffer) {
+	for b.Len()%4 != 0 {
+		b.WriteByte(0)
+	}
+}
+
+// size returns the size of the core file in bytes.
+func (c *coreDump) size() uint64 {
+	size := c.dataOff
+	for _, r := range c.regions {
+		if r.Dump {
+			size += r.Range.Length()
+		}
+	}
+	return size
+}
+
+// writeTo writes the core file to w, reading the memory of t.
+func (c *coreDump) writeTo(t *Task, w *coreWriter) error {
+	if _, err := w.Write(c.headers); err != nil {
+		return err
+	}
+	if _, err := w.Write(make([]byte, c.dataOff-uint64(len(c.headers)))); err != nil {
+		return err
+	}
+	m := t.MemoryManager()
+	buf := make([]byte, coreChunkSize)
+	for _, r := range c.regions {
+		if !r.Dump {
+			continue
+		}
+		for addr := r.Range.Start; addr < r.Range.End; {
+			b := buf
+			if uint64(len(b)) > uint64(r.Range.End-addr) {
+				b = b[:r.Range.End-addr]
+			}
+			n, err := m.CopyIn(t, addr, b, usermem.IOOpts{IgnorePermissions: true})
+			if err != nil {
+				// As in Linux, pages that can't be read, such as those of
+				// file mappings beyond the end of the file, are dumped as
+				// zeros.
+				end := (n/usermem.PageSize + 1) * usermem.PageSize
+				if end > len(b) {
+					end = len(b)
+				}
+				for i := n; i < end; i++ {
+					b[i] = 0
+				}
+				b = b[:end]
+			}
+			if _, err := w.Write(b); err != nil {
+				return err
+			}
+			addr += usermem.Addr(len(b))
+		}
+	}
+	return nil
+}
+
+// coreWriter writes at most limit bytes to w.
+type coreWriter struct {
+	w     io.Writer
+	limit uint64
+	n     uint64
+}
+
+// Write implements io.Writer.Write.
+func (w *coreWriter) Write(b []byte) (int, error) {
+	if w.limit-w.n < uint64(len(b)) {
+		b = b[:w.limit-w.n]
+		n, err := w.w.Write(b)
+		w.n += uint64(n)
+		if err == nil {
+			err = errCoreLimit
+		}
+		return n, err
+	}
+	n, err := w.w.Write(b)
+	w.n += uint64(n)
+	return n, err
+}
+
+// fileWriter writes to a file description at its offset.
+type fileWriter struct {
+	t  *Task
+	fd *vfs.FileDescription
+}
+
+// Write implements io.Writer.Write.
+func (w *fileWriter) Write(b []byte) (int, error) {
+	n, err := w.fd.Write(w.t, usermem.BytesIOSequence(b), vfs.WriteOptions{})
+	return int(n), err
+}
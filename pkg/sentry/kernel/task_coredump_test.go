@@ -0,0 +1,74 @@
+// Copyright 2021 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kernel
+
+import (
+	"testing"
+)
+
+func TestExpandCorePattern(t *testing.T) {
+	ci := coreNameInfo{
+		pid:      2,
+		rootPID:  20,
+		tid:      3,
+		rootTID:  30,
+		uid:      1000,
+		gid:      1001,
+		signo:    11,
+		time:     1600000000,
+		hostname: "host/name",
+		name:     "a/b.out",
+	}
+	for _, test := range []struct {
+		pattern string
+		want    string
+	}{
+		{
+			pattern: "core",
+			want:    "core",
+		},
+		{
+			pattern: "core.%p.%i.%P.%I",
+			want:    "core.2.3.20.30",
+		},
+		{
+			pattern: "/tmp/core-%u-%g-%s-%t",
+			want:    "/tmp/core-1000-1001-11-1600000000",
+		},
+		{
+			// Slashes in names don't create path components.
+			pattern: "%h/%e",
+			want:    "host!name/a!b.out",
+		},
+		{
+			pattern: "100%%",
+			want:    "100%",
+		},
+		{
+			// Unknown specifiers are dropped.
+			pattern: "core%z.%p",
+			want:    "core.2",
+		},
+		{
+			// A trailing '%' is kept.
+			pattern: "core%",
+			want:    "core%",
+		},
+	} {
+		if got := expandCorePattern(test.pattern, ci); got != test.want {
+			t.Errorf("expandCorePattern(%q) got %q, want %q", test.pattern, got, test.want)
+		}
+	}
+}
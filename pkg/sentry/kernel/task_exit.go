@@ -48,6 +48,10 @@ type ExitStatus struct {
 	// Signo is the signal that caused the exit. If the exit was not caused by
 	// a signal, Signo is 0.
 	Signo int
+
+	// CoreDumped is true if the signal that caused the exit produced a core
+	// dump.
+	CoreDumped bool
 }
 
 // Signaled returns true if the ExitStatus indicates that the exiting task or
@@ -59,7 +63,12 @@ func (es ExitStatus) Signaled() bool {
 // Status returns the numeric representation of the ExitStatus returned by e.g.
 // the wait4() system call.
 func (es ExitStatus) Status() uint32 {
-	return ((uint32(es.Code) & 0xff) << 8) | (uint32(es.Signo) & 0xff)
+	status := ((uint32(es.Code) & 0xff) << 8) | (uint32(es.Signo) & 0xff)
+	if es.CoreDumped {
+		// WCOREFLAG
+		status |= 0x80
+	}
+	return status
 }
 
 // ShellExitCode returns the numeric exit code that Bash would return for an
@@ -685,6 +694,7 @@ func (t *Task) exitNotifyLocked(fromPtraceDetach bool) {
 			t.tg.leader.exitNotifyLocked(false)
 		} else if tc == 0 {
 			t.tg.processGroup.decRefWithParent(t.tg.parentPG())
+			t.tg.pidns.owner.accountExitedThreadGroupLocked(t.containerID, t.tg)
 		}
 		if t.parent != nil {
 			delete(t.parent.children, t)
@@ -702,6 +712,9 @@ func (t *Task) exitNotificationSignal(sig linux.Signal, receiver *Task) *arch.Si
 	info.SetUID(int32(t.Credentials().RealKUID.In(receiver.UserNamespace()).OrOverflow()))
 	if t.exitStatus.Signaled() {
 		info.Code = arch.CLD_KILLED
+		if t.exitStatus.CoreDumped {
+			info.Code = arch.CLD_DUMPED
+		}
 		info.SetStatus(int32(t.exitStatus.Signo))
 	} else {
 		info.Code = arch.CLD_EXITED
@@ -42,3 +42,11 @@ func (t *Task) NetworkNamespace() *inet.Namespace {
 	defer t.mu.Unlock()
 	return t.netns
 }
+
+// SetNetworkNamespace makes the task observe the network namespace ns, as by
+// setns(2).
+func (t *Task) SetNetworkNamespace(ns *inet.Namespace) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.netns = ns
+}
@@ -586,6 +586,21 @@ func (t *Task) CPUMask() sched.CPUSet {
 	return t.allowedCPUMask.Copy()
 }
 
+// OnlineCPUMask returns a copy of t's allowed CPU mask, restricted to CPUs
+// that are online. As in Linux, if none of the CPUs that t is allowed to run
+// on are online, t may run on any online CPU.
+func (t *Task) OnlineCPUMask() sched.CPUSet {
+	mask := t.CPUMask()
+	online := t.k.OnlineCores()
+	mask.ClearAbove(online)
+	if mask.NumCPUs() == 0 {
+		for cpu := uint(0); cpu < online; cpu++ {
+			mask.Set(cpu)
+		}
+	}
+	return mask
+}
+
 // SetCPUMask sets t's allowed CPU mask based on mask. It takes ownership of
 // mask.
 //
@@ -626,7 +641,13 @@ func (t *Task) CPU() int32 {
 		return int32(hostcpu.GetCPU())
 	}
 
-	return atomic.LoadInt32(&t.cpu)
+	cpu := atomic.LoadInt32(&t.cpu)
+	// Tasks that were assigned a CPU that has since been taken offline
+	// appear to have migrated to an online CPU.
+	if online := int32(t.k.OnlineCores()); cpu >= online {
+		cpu %= online
+	}
+	return cpu
 }
 
 // assignCPU returns the virtualized CPU number for the task with global TID
@@ -204,7 +204,11 @@ func (t *Task) deliverSignal(info *arch.SignalInfo, act arch.SignalAct) taskRunS
 
 		eventchannel.Emit(ucs)
 
-		t.PrepareGroupExit(ExitStatus{Signo: int(info.Signo)})
+		es := ExitStatus{Signo: int(info.Signo)}
+		if sigact == SignalActionCore {
+			es.CoreDumped = t.dumpCore(info)
+		}
+		t.PrepareGroupExit(es)
 		return (*runExit)(nil)
 
 	case SignalActionStop:
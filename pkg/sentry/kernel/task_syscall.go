@@ -19,11 +19,13 @@ import (
 	"os"
 	"runtime/trace"
 	"syscall"
+	"time"
 
 	"gvisor.dev/gvisor/pkg/abi/linux"
 	"gvisor.dev/gvisor/pkg/bits"
 	"gvisor.dev/gvisor/pkg/marshal"
 	"gvisor.dev/gvisor/pkg/metric"
+	"gvisor.dev/gvisor/pkg/otlp"
 	"gvisor.dev/gvisor/pkg/sentry/arch"
 	"gvisor.dev/gvisor/pkg/sentry/memmap"
 	"gvisor.dev/gvisor/pkg/syserror"
@@ -32,6 +34,10 @@ import (
 
 var vsyscallCount = metric.MustCreateNewUint64Metric("/kernel/vsyscall_count", false /* sync */, "Number of times vsyscalls were invoked by the application")
 
+// SyscallSpanThreshold, if non-zero, causes a span to be exported with
+// otlp.Default for each syscall that takes at least this long.
+var SyscallSpanThreshold time.Duration
+
 // SyscallRestartBlock represents the restart block for a syscall restartable
 // with a custom function. It encapsulates the state required to restart a
 // syscall across a S/R.
@@ -99,6 +105,10 @@ func (t *Task) executeSyscall(sysno uintptr, args arch.SyscallArguments) (rval u
 		if trace.IsEnabled() {
 			region = trace.StartRegion(t.traceContext, s.LookupName(sysno))
 		}
+		var start time.Time // Only non-zero if spans or stats are enabled.
+		if SyscallSpanThreshold != 0 || s.stats != nil {
+			start = time.Now()
+		}
 		if fn != nil {
 			// Call our syscall implementation.
 			rval, ctrl, err = fn(t, args)
@@ -109,6 +119,22 @@ func (t *Task) executeSyscall(sysno uintptr, args arch.SyscallArguments) (rval u
 		if region != nil {
 			region.End()
 		}
+		if !start.IsZero() {
+			end := time.Now()
+			d := end.Sub(start)
+			if s.stats != nil {
+				s.stats.record(t, sysno, args, rval, err, start, d)
+			}
+			if SyscallSpanThreshold != 0 && d >= SyscallSpanThreshold {
+				otlp.Default().Record("syscall "+s.LookupName(sysno), start, end, err,
+					otlp.Int("syscall.nr", int64(sysno)),
+					otlp.Int("thread.id", int64(t.ThreadID())))
+			}
+		}
+	}
+
+	if t.k.auditing() {
+		t.audit(sysno, args, rval, err)
 	}
 
 	if bits.IsOn32(fe, ExternalAfterEnable) && (s.ExternalFilterAfter == nil || s.ExternalFilterAfter(t, sysno, args)) {
@@ -15,6 +15,7 @@
 package kernel
 
 import (
+	"reflect"
 	"testing"
 
 	"gvisor.dev/gvisor/pkg/sentry/kernel/sched"
@@ -67,3 +68,70 @@ func TestTaskCPU(t *testing.T) {
 	}
 
 }
+
+// cpuSet returns a CPUSet of size cpus containing the given CPUs.
+func cpuSet(size uint, cpus ...uint) sched.CPUSet {
+	mask := sched.NewCPUSet(size)
+	for _, cpu := range cpus {
+		mask.Set(cpu)
+	}
+	return mask
+}
+
+func TestOnlineCPUMask(t *testing.T) {
+	for _, test := range []struct {
+		name    string
+		online  uint32
+		allowed sched.CPUSet
+		want    sched.CPUSet
+	}{
+		{
+			name:    "all online",
+			online:  4,
+			allowed: cpuSet(4, 0, 1, 2, 3),
+			want:    cpuSet(4, 0, 1, 2, 3),
+		},
+		{
+			name:    "some allowed offline",
+			online:  2,
+			allowed: cpuSet(4, 1, 2, 3),
+			want:    cpuSet(4, 1),
+		},
+		{
+			// Tasks whose allowed CPUs are all offline may run on any
+			// online CPU.
+			name:    "all allowed offline",
+			online:  2,
+			allowed: cpuSet(4, 2, 3),
+			want:    cpuSet(4, 0, 1),
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			task := &Task{
+				k:              &Kernel{applicationCores: 4, onlineCores: test.online},
+				allowedCPUMask: test.allowed,
+			}
+			if got := task.OnlineCPUMask(); !reflect.DeepEqual(got, test.want) {
+				t.Errorf("OnlineCPUMask() got %v, want %v", got, test.want)
+			}
+			// The allowed mask itself is unchanged, so that it applies again
+			// once its CPUs are back online.
+			if got := task.CPUMask(); !reflect.DeepEqual(got, test.allowed) {
+				t.Errorf("CPUMask() got %v, want %v", got, test.allowed)
+			}
+		})
+	}
+}
+
+func TestCPUOffline(t *testing.T) {
+	k := &Kernel{applicationCores: 4, onlineCores: 4}
+	task := &Task{k: k, cpu: 3}
+	if got := task.CPU(); got != 3 {
+		t.Errorf("CPU() got %d, want 3", got)
+	}
+	// A task on an offline CPU appears to have migrated to an online one.
+	k.onlineCores = 2
+	if got := task.CPU(); got != 1 {
+		t.Errorf("CPU() with 2 online CPUs got %d, want 1", got)
+	}
+}
@@ -262,6 +262,12 @@ type ThreadGroup struct {
 	//
 	// oomScoreAdj is accessed using atomic memory operations.
 	oomScoreAdj int32
+
+	// cgroup is the emulated cgroup the thread group is a member of, or nil
+	// if there is no emulated cgroup hierarchy.
+	//
+	// cgroup is protected by the TaskSet mutex.
+	cgroup Cgroup
 }
 
 // NewThreadGroup returns a new, empty thread group in PID namespace pidns. The
@@ -278,6 +284,7 @@ func (k *Kernel) NewThreadGroup(mntns *fs.MountNamespace, pidns *PIDNamespace, s
 		ioUsage:           &usage.IO{},
 		limits:            limits,
 		mounts:            mntns,
+		cgroup:            k.cgroupRoot,
 	}
 	tg.itimerRealTimer = ktime.NewTimer(k.monotonicClock, &itimerRealListener{tg: tg})
 	tg.timers = make(map[linux.TimerID]*IntervalTimer)
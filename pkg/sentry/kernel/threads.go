@@ -96,6 +96,10 @@ type TaskSet struct {
 	// aioGoroutines is not saved but is required to be zero at the time of
 	// save.
 	aioGoroutines sync.WaitGroup `state:"nosave"`
+
+	// exitedContainerUsage maps container IDs to the resource usage of their
+	// exited thread groups. exitedContainerUsage is protected by mu.
+	exitedContainerUsage map[string]*ContainerUsage
 }
 
 // newTaskSet returns a new, empty TaskSet.
@@ -100,6 +100,18 @@ type Mappable interface {
 	InvalidateUnsavable(ctx context.Context) error
 }
 
+// Prefetcher is an optional interface implemented by Mappables that can
+// populate their contents before they are translated, such that later calls
+// to Translate are cheaper; it is analogous to Linux's readahead.
+type Prefetcher interface {
+	// Prefetch requests that the Mappable populate its contents for the range
+	// of offsets specified by mr. It is advisory: errors are only reported to
+	// the caller for informational purposes.
+	//
+	// Preconditions: Same as Mappable.Translate.
+	Prefetch(ctx context.Context, mr MappableRange) error
+}
+
 // Translations are returned by Mappable.Translate.
 type Translation struct {
 	// Source is the translated range in the Mappable.
@@ -0,0 +1,81 @@
+// Copyright 2020 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mm
+
+import (
+	"gvisor.dev/gvisor/pkg/syserror"
+	"gvisor.dev/gvisor/pkg/usermem"
+)
+
+// CoreRegion is a mapped region of an address space, as described by a core
+// dump.
+type CoreRegion struct {
+	// Range is the range of addresses of the region.
+	Range usermem.AddrRange
+
+	// Perms are the application's permissions on the region.
+	Perms usermem.AccessType
+
+	// Dump is true if the contents of the region are included in core dumps.
+	Dump bool
+}
+
+// CoreRegions returns the regions of mm's address space, in ascending order of
+// address. As with Linux's default /proc/[pid]/coredump_filter, the contents
+// of anonymous mappings and of private mappings that may have been written are
+// dumped, and those of other file mappings aren't. Mappings for which
+// madvise(MADV_DONTDUMP) was called are never dumped. See Linux's
+// fs/binfmt_elf.c:vma_dump_size().
+func (mm *MemoryManager) CoreRegions() []CoreRegion {
+	mm.mappingMu.RLock()
+	defer mm.mappingMu.RUnlock()
+	var regions []CoreRegion
+	for vseg := mm.vmas.FirstSegment(); vseg.Ok(); vseg = vseg.NextSegment() {
+		vma := vseg.ValuePtr()
+		regions = append(regions, CoreRegion{
+			Range: vseg.Range(),
+			Perms: vma.realPerms,
+			Dump:  !vma.dontdump && vma.maxPerms.Read && (vma.mappable == nil || (vma.private && vma.maxPerms.Write)),
+		})
+	}
+	return regions
+}
+
+// SetDontDump implements the semantics of madvise(MADV_DONTDUMP) if dontdump
+// is true, and of madvise(MADV_DODUMP) otherwise.
+func (mm *MemoryManager) SetDontDump(addr usermem.Addr, length uint64, dontdump bool) error {
+	ar, ok := addr.ToRange(length)
+	if !ok {
+		return syserror.EINVAL
+	}
+
+	mm.mappingMu.Lock()
+	defer mm.mappingMu.Unlock()
+	defer func() {
+		mm.vmas.MergeRange(ar)
+		mm.vmas.MergeAdjacent(ar)
+	}()
+
+	for vseg := mm.vmas.LowerBoundSegment(ar.Start); vseg.Ok() && vseg.Start() < ar.End; vseg = vseg.NextSegment() {
+		vseg = mm.vmas.Isolate(vseg, ar)
+		vma := vseg.ValuePtr()
+		vma.dontdump = dontdump
+	}
+
+	if mm.vmas.SpanRange(ar) != ar.Length() {
+		return syserror.ENOMEM
+	}
+	return nil
+}
@@ -504,6 +504,9 @@ func (mm *MemoryManager) withInternalMappings(ctx context.Context, ar usermem.Ad
 	mm.activeMu.RLock()
 	if pseg := mm.existingPMAsLocked(ar, at, ignorePermissions, true /* needInternalMappings */); pseg.Ok() {
 		n, err := f(mm.internalMappingsLocked(pseg, ar))
+		if at.Write {
+			mm.markWrittenLocked(ar)
+		}
 		mm.activeMu.RUnlock()
 		// Do not convert errors returned by f to EFAULT.
 		return int64(n), err
@@ -544,6 +547,9 @@ func (mm *MemoryManager) withInternalMappings(ctx context.Context, ar usermem.Ad
 
 	// Do I/O.
 	un, err := f(mm.internalMappingsLocked(pseg, ar))
+	if at.Write {
+		mm.markWrittenLocked(ar)
+	}
 	mm.activeMu.RUnlock()
 	n := int64(un)
 
@@ -580,6 +586,9 @@ func (mm *MemoryManager) withVecInternalMappings(ctx context.Context, ars userme
 	mm.activeMu.RLock()
 	if mm.existingVecPMAsLocked(ars, at, ignorePermissions, true /* needInternalMappings */) {
 		n, err := f(mm.vecInternalMappingsLocked(ars))
+		if at.Write {
+			mm.vecMarkWrittenLocked(ars)
+		}
 		mm.activeMu.RUnlock()
 		// Do not convert errors returned by f to EFAULT.
 		return int64(n), err
@@ -611,6 +620,9 @@ func (mm *MemoryManager) withVecInternalMappings(ctx context.Context, ars userme
 
 	// Do I/O.
 	un, err := f(mm.vecInternalMappingsLocked(imars))
+	if at.Write {
+		mm.vecMarkWrittenLocked(imars)
+	}
 	mm.activeMu.RUnlock()
 	n := int64(un)
 
@@ -298,6 +298,10 @@ type vma struct {
 	// dontfork is the MADV_DONTFORK setting for this vma configured by madvise().
 	dontfork bool
 
+	// dontdump is the MADV_DONTDUMP setting for this vma configured by
+	// madvise().
+	dontdump bool
+
 	mlockMode memmap.MLockMode
 
 	// numaPolicy is the NUMA policy for this vma set by mbind().
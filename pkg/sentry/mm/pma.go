@@ -880,6 +880,41 @@ func (mm *MemoryManager) vecInternalMappingsLocked(ars usermem.AddrRangeSeq) saf
 	return safemem.BlockSeqFromSlice(ims)
 }
 
+// dirtyMarker is implemented by memmap.Files that track writes to their pages,
+// such as pgalloc.MemoryFile.
+type dirtyMarker interface {
+	// MarkDirty records that the contents of fr may have changed.
+	MarkDirty(fr memmap.FileRange)
+}
+
+// markWrittenLocked informs files that track writes that addresses in ar have
+// been written through cached internal mappings, which such files can't
+// otherwise observe.
+//
+// Preconditions:
+// * mm.activeMu must be locked.
+// * pmas must exist for all addresses in ar.
+func (mm *MemoryManager) markWrittenLocked(ar usermem.AddrRange) {
+	if ar.Length() == 0 {
+		return
+	}
+	for pseg := mm.pmas.FindSegment(ar.Start); pseg.Ok() && pseg.Start() < ar.End; pseg = pseg.NextSegment() {
+		if dm, ok := pseg.ValuePtr().file.(dirtyMarker); ok {
+			dm.MarkDirty(pseg.fileRangeOf(pseg.Range().Intersect(ar)))
+		}
+	}
+}
+
+// vecMarkWrittenLocked is equivalent to calling markWrittenLocked on each
+// AddrRange in ars.
+//
+// Preconditions: Same as markWrittenLocked.
+func (mm *MemoryManager) vecMarkWrittenLocked(ars usermem.AddrRangeSeq) {
+	for ; !ars.IsEmpty(); ars = ars.Tail() {
+		mm.markWrittenLocked(ars.Head())
+	}
+}
+
 // incPrivateRef acquires a reference on private pages in fr.
 func (mm *MemoryManager) incPrivateRef(fr memmap.FileRange) {
 	mm.privateRefs.mu.Lock()
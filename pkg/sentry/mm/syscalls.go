@@ -1121,6 +1121,37 @@ func (mm *MemoryManager) Decommit(addr usermem.Addr, length uint64) error {
 	return nil
 }
 
+// WillNeed implements the semantics of Linux's MADV_WILLNEED: it prefetches
+// the contents of file mappings in the given range whose Mappables support
+// it.
+func (mm *MemoryManager) WillNeed(ctx context.Context, addr usermem.Addr, length uint64) error {
+	ar, ok := addr.ToRange(length)
+	if !ok {
+		return syserror.EINVAL
+	}
+
+	mm.mappingMu.RLock()
+	defer mm.mappingMu.RUnlock()
+	for vseg := mm.vmas.LowerBoundSegment(ar.Start); vseg.Ok() && vseg.Start() < ar.End; vseg = vseg.NextSegment() {
+		p, ok := vseg.ValuePtr().mappable.(memmap.Prefetcher)
+		if !ok {
+			continue
+		}
+		mr := vseg.mappableRangeOf(vseg.Range().Intersect(ar))
+		if err := p.Prefetch(ctx, mr); err != nil {
+			// Like Linux, ignore I/O errors, which will be reported when the
+			// range is faulted in.
+			ctx.Debugf("Failed to prefetch %v: %v", mr, err)
+		}
+	}
+
+	// See comment in Decommit.
+	if mm.vmas.SpanRange(ar) != ar.Length() {
+		return syserror.ENOMEM
+	}
+	return nil
+}
+
 // MSyncOpts holds options to MSync.
 type MSyncOpts struct {
 	// Sync has the semantics of MS_SYNC.
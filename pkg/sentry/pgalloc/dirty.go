@@ -0,0 +1,446 @@
+// Copyright 2020 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pgalloc
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"math"
+	"sync/atomic"
+
+	"gvisor.dev/gvisor/pkg/sentry/memmap"
+	"gvisor.dev/gvisor/pkg/sentry/usage"
+	"gvisor.dev/gvisor/pkg/state"
+	"gvisor.dev/gvisor/pkg/state/wire"
+	"gvisor.dev/gvisor/pkg/usermem"
+)
+
+// WriteTracker reports writes to MemoryFile pages that the MemoryFile can't
+// observe itself, such as writes by application code to pages that a platform
+// has mapped into application address spaces.
+type WriteTracker interface {
+	// CollectDirty calls fn for each range of host virtual addresses that
+	// may have been written since the previous call to CollectDirty.
+	CollectDirty(fn func(addr, length uintptr)) error
+}
+
+// StartDirtyTracking causes f to record which of its pages are written, so
+// that SaveDirtyTo can save only the pages that have changed since the last
+// call to SaveTo or SaveDirtyTo. Writes through mappings returned by
+// MapInternal, and writes by users of memmap.File that call MarkDirty, are
+// observed by f; all other writes must be reported by wt.
+//
+// StartDirtyTracking may be called at most once.
+func (f *MemoryFile) StartDirtyTracking(wt WriteTracker) {
+	f.dirtyMu.Lock()
+	defer f.dirtyMu.Unlock()
+	f.writeTracker = wt
+	atomic.StoreUint32(&f.dirtyTracking, 1)
+}
+
+// MarkDirty records that the contents of the pages in fr may have changed.
+// It has no effect unless dirty tracking is enabled; see StartDirtyTracking.
+//
+// Callers that retain internal mappings of f across writes (rather than
+// calling MapInternal for each write) must call MarkDirty after each write.
+func (f *MemoryFile) MarkDirty(fr memmap.FileRange) {
+	if atomic.LoadUint32(&f.dirtyTracking) == 0 {
+		return
+	}
+	fr.Start &^= usermem.PageSize - 1
+	fr.End = (fr.End + usermem.PageSize - 1) &^ (usermem.PageSize - 1)
+	if fr.Length() == 0 {
+		return
+	}
+	f.dirtyMu.Lock()
+	defer f.dirtyMu.Unlock()
+	f.markDirtyLocked(fr)
+}
+
+// Preconditions: f.dirtyMu must be locked.
+func (f *MemoryFile) markDirtyLocked(fr memmap.FileRange) {
+	gap := f.dirty.LowerBoundGap(fr.Start)
+	for gap.Ok() && gap.Start() < fr.End {
+		gapFR := gap.Range().Intersect(fr)
+		if gapFR.Length() == 0 {
+			gap = gap.NextGap()
+			continue
+		}
+		gap = f.dirty.Insert(gap, gapFR, dirtySetValue{}).NextGap()
+	}
+}
+
+// collectDirtyLocked adds pages reported by f.writeTracker to f.dirty.
+//
+// Preconditions: f.dirtyMu must be locked.
+func (f *MemoryFile) collectDirtyLocked() error {
+	if f.writeTracker == nil {
+		return nil
+	}
+	mappings := f.mappings.Load().([]uintptr)
+	return f.writeTracker.CollectDirty(func(addr, length uintptr) {
+		// Translate host virtual addresses in our chunk mappings to file
+		// offsets. Addresses outside of the chunk mappings belong to
+		// something else and are ignored.
+		end := addr + length
+		for chunk := range mappings {
+			m := atomic.LoadUintptr(&mappings[chunk])
+			if m == 0 || end <= m || m+chunkSize <= addr {
+				continue
+			}
+			start, stop := addr, end
+			if start < m {
+				start = m
+			}
+			if stop > m+chunkSize {
+				stop = m + chunkSize
+			}
+			off := uint64(chunk) << chunkShift
+			f.markDirtyLocked(memmap.FileRange{off + uint64(start-m), off + uint64(stop-m)})
+		}
+	})
+}
+
+// resetDirtyLocked discards all record of written pages, such that pages are
+// only considered dirty if they are written after resetDirtyLocked returns.
+//
+// Preconditions: f.mu must be locked.
+func (f *MemoryFile) resetDirtyLocked() error {
+	if atomic.LoadUint32(&f.dirtyTracking) == 0 {
+		return nil
+	}
+	f.dirtyMu.Lock()
+	defer f.dirtyMu.Unlock()
+	// Drain the WriteTracker, so that writes it has already seen aren't
+	// reported again.
+	if err := f.collectDirtyLocked(); err != nil {
+		return err
+	}
+	f.dirty.RemoveAll()
+	f.dirtyBaseline = true
+	return nil
+}
+
+// SaveDirtyTo writes the pages of f that have changed since the last call to
+// SaveTo, SaveDirtyTo or SaveLiveTo to the given stream, along with f's current metadata.
+// Applying the result to a MemoryFile restored from the earlier state, using
+// LoadDirtyFrom, reproduces f's current state.
+//
+// As for SaveTo, all writers to f must be stopped, and there must be no
+// pending evictions.
+func (f *MemoryFile) SaveDirtyTo(ctx context.Context, w wire.Writer) error {
+	if atomic.LoadUint32(&f.dirtyTracking) == 0 {
+		return fmt.Errorf("dirty tracking is not enabled")
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.waitForReclaimLocked()
+	if len(f.evictable) != 0 {
+		panic(fmt.Sprintf("evictions still pending for %d users; call StartEvictions and WaitForEvictions before SaveDirtyTo", len(f.evictable)))
+	}
+
+	// Take the set of dirty pages, leaving f.dirty empty for the next
+	// increment.
+	f.dirtyMu.Lock()
+	if !f.dirtyBaseline {
+		f.dirtyMu.Unlock()
+		return fmt.Errorf("SaveDirtyTo called before SaveTo")
+	}
+	dirty, err := f.takeDirtyLocked()
+	f.dirtyMu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	if err := f.saveMetadataLocked(ctx, w); err != nil {
+		return err
+	}
+	_, err = f.saveRanges(w, dirty)
+	return err
+}
+
+// SaveLiveTo writes an increment in the format used by SaveDirtyTo to the
+// given stream, without requiring writers to f to be stopped. If full is true,
+// all allocated pages are saved, and the result may be applied by
+// LoadDirtyFrom to a MemoryFile that has never been used; otherwise, only the
+// pages that have changed since the last call to SaveLiveTo, SaveDirtyTo or
+// SaveTo are saved. SaveLiveTo returns the number of bytes of page contents
+// saved.
+//
+// Pages that are written while SaveLiveTo is running may be saved in an
+// inconsistent state. Since such pages are also recorded as dirty, they are
+// saved again by the next increment, so the last increment must be written by
+// SaveDirtyTo once writers have been stopped.
+func (f *MemoryFile) SaveLiveTo(ctx context.Context, w wire.Writer, full bool) (uint64, error) {
+	if atomic.LoadUint32(&f.dirtyTracking) == 0 {
+		return 0, fmt.Errorf("dirty tracking is not enabled")
+	}
+
+	f.mu.Lock()
+	f.waitForReclaimLocked()
+	f.dirtyMu.Lock()
+	var (
+		ranges []memmap.FileRange
+		err    error
+	)
+	switch {
+	case full:
+		// Drain the WriteTracker, since every page is being saved anyway.
+		if err = f.collectDirtyLocked(); err == nil {
+			f.dirty.RemoveAll()
+			for seg := f.usage.FirstSegment(); seg.Ok(); seg = seg.NextSegment() {
+				ranges = append(ranges, seg.Range())
+			}
+			f.dirtyBaseline = true
+		}
+	case !f.dirtyBaseline:
+		err = fmt.Errorf("SaveLiveTo called without a full save")
+	default:
+		ranges, err = f.takeDirtyLocked()
+	}
+	f.dirtyMu.Unlock()
+	if err == nil {
+		err = f.saveMetadataLocked(ctx, w)
+	}
+	// Pages are copied without holding f.mu, so that allocation and
+	// deallocation can proceed. Pages that are freed in the meantime remain
+	// mapped (since f never shrinks), and are decommitted by the next
+	// increment's LoadDirtyFrom.
+	f.mu.Unlock()
+	if err != nil {
+		return 0, err
+	}
+	return f.saveRanges(w, ranges)
+}
+
+// DirtyBytes returns the number of bytes of pages that have been written since
+// the last call to SaveLiveTo, SaveDirtyTo or SaveTo, i.e. the amount of page
+// contents that the next increment would save if no more pages were written.
+func (f *MemoryFile) DirtyBytes() (uint64, error) {
+	if atomic.LoadUint32(&f.dirtyTracking) == 0 {
+		return 0, fmt.Errorf("dirty tracking is not enabled")
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.dirtyMu.Lock()
+	defer f.dirtyMu.Unlock()
+	if err := f.collectDirtyLocked(); err != nil {
+		return 0, err
+	}
+	var n uint64
+	for seg := f.dirty.FirstSegment(); seg.Ok(); seg = seg.NextSegment() {
+		for useg := f.usage.LowerBoundSegment(seg.Start()); useg.Ok() && useg.Start() < seg.End(); useg = useg.NextSegment() {
+			n += useg.Range().Intersect(seg.Range()).Length()
+		}
+	}
+	return n, nil
+}
+
+// takeDirtyLocked returns the allocated pages in f.dirty, including those
+// reported by f.writeTracker, and empties f.dirty.
+//
+// Preconditions: f.mu and f.dirtyMu must be locked.
+func (f *MemoryFile) takeDirtyLocked() ([]memmap.FileRange, error) {
+	if err := f.collectDirtyLocked(); err != nil {
+		return nil, err
+	}
+	var dirty []memmap.FileRange
+	for seg := f.dirty.FirstSegment(); seg.Ok(); seg = seg.NextSegment() {
+		// Pages that aren't allocated have been freed, and will be
+		// decommitted by LoadDirtyFrom; their contents needn't be saved.
+		for useg := f.usage.LowerBoundSegment(seg.Start()); useg.Ok() && useg.Start() < seg.End(); useg = useg.NextSegment() {
+			dirty = append(dirty, useg.Range().Intersect(seg.Range()))
+		}
+	}
+	f.dirty.RemoveAll()
+	return dirty, nil
+}
+
+// Preconditions: f.mu must be locked.
+func (f *MemoryFile) saveMetadataLocked(ctx context.Context, w wire.Writer) error {
+	if _, err := state.Save(ctx, w, &f.fileSize); err != nil {
+		return err
+	}
+	_, err := state.Save(ctx, w, &f.usage)
+	return err
+}
+
+// saveRanges writes the contents of the given ranges to w, each preceded by
+// its offset and length, and returns the number of bytes of page contents
+// written.
+func (f *MemoryFile) saveRanges(w wire.Writer, ranges []memmap.FileRange) (uint64, error) {
+	if err := state.WriteHeader(w, uint64(len(ranges)), false); err != nil {
+		return 0, err
+	}
+	var n uint64
+	for _, fr := range ranges {
+		if err := state.WriteHeader(w, fr.Start, false); err != nil {
+			return n, err
+		}
+		if err := state.WriteHeader(w, fr.Length(), false); err != nil {
+			return n, err
+		}
+		var ioErr error
+		err := f.forEachMappingSlice(fr, func(s []byte) {
+			if ioErr != nil {
+				return
+			}
+			_, ioErr = w.Write(s)
+		})
+		if ioErr != nil {
+			return n, ioErr
+		}
+		if err != nil {
+			return n, err
+		}
+		n += fr.Length()
+	}
+	return n, nil
+}
+
+// LoadDirtyFrom applies changes written by SaveDirtyTo to f, which must hold
+// the state saved by the preceding call to SaveTo, SaveDirtyTo or SaveLiveTo
+// on the source MemoryFile (either restored by LoadFrom or updated by a
+// previous call to LoadDirtyFrom), or be unused if the changes were written
+// by a full SaveLiveTo.
+//
+// Preconditions: f must not be in use.
+func (f *MemoryFile) LoadDirtyFrom(ctx context.Context, r wire.Reader) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	// Load metadata.
+	var fileSize int64
+	if _, err := state.Load(ctx, r, &fileSize); err != nil {
+		return err
+	}
+	if fileSize < f.fileSize {
+		return fmt.Errorf("saved file size %d is smaller than current file size %d", fileSize, f.fileSize)
+	}
+	if fileSize > f.fileSize {
+		if err := f.file.Truncate(fileSize); err != nil {
+			return err
+		}
+		f.fileSize = fileSize
+		f.mappingsMu.Lock()
+		oldMappings := f.mappings.Load().([]uintptr)
+		newMappings := make([]uintptr, fileSize>>chunkShift)
+		copy(newMappings, oldMappings)
+		f.mappings.Store(newMappings)
+		f.mappingsMu.Unlock()
+	}
+
+	// Remember the old allocations, so that pages that have since been freed
+	// can be decommitted.
+	type oldSegment struct {
+		fr  memmap.FileRange
+		val usageInfo
+	}
+	var old []oldSegment
+	for seg := f.usage.FirstSegment(); seg.Ok(); seg = seg.NextSegment() {
+		old = append(old, oldSegment{seg.Range(), seg.Value()})
+	}
+	f.usage.RemoveAll()
+	if _, err := state.Load(ctx, r, &f.usage); err != nil {
+		return err
+	}
+	for _, o := range old {
+		if o.val.knownCommitted {
+			usage.MemoryAccounting.Dec(o.fr.Length(), o.val.kind)
+			f.usageExpected -= o.fr.Length()
+		}
+		for gap := f.usage.LowerBoundGap(o.fr.Start); gap.Ok() && gap.Start() < o.fr.End; gap = gap.NextGap() {
+			if fr := gap.Range().Intersect(o.fr); fr.Length() != 0 {
+				if err := f.decommitFile(fr); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	for seg := f.usage.FirstSegment(); seg.Ok(); seg = seg.NextSegment() {
+		if seg.Value().knownCommitted {
+			usage.MemoryAccounting.Inc(seg.Range().Length(), seg.Value().kind)
+			f.usageExpected += seg.Range().Length()
+		}
+	}
+
+	// Load dirty pages.
+	count, object, err := state.ReadHeader(r)
+	if err != nil {
+		return err
+	}
+	if object {
+		return fmt.Errorf("unexpected object")
+	}
+	for i := uint64(0); i < count; i++ {
+		start, object, err := state.ReadHeader(r)
+		if err != nil {
+			return err
+		}
+		if object {
+			return fmt.Errorf("unexpected object")
+		}
+		length, object, err := state.ReadHeader(r)
+		if err != nil {
+			return err
+		}
+		if object {
+			return fmt.Errorf("unexpected object")
+		}
+		fr := memmap.FileRange{start, start + length}
+		if length == 0 || start > math.MaxUint64-length || fr.End > uint64(f.fileSize) {
+			return fmt.Errorf("invalid dirty range %v", fr)
+		}
+		var ioErr error
+		err = f.forEachMappingSlice(fr, func(s []byte) {
+			if ioErr != nil {
+				return
+			}
+			_, ioErr = io.ReadFull(r, s)
+		})
+		if ioErr != nil {
+			return ioErr
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type dirtySetValue struct{}
+
+type dirtySetFunctions struct{}
+
+func (dirtySetFunctions) MinKey() uint64 {
+	return 0
+}
+
+func (dirtySetFunctions) MaxKey() uint64 {
+	return math.MaxUint64
+}
+
+func (dirtySetFunctions) ClearValue(val *dirtySetValue) {
+}
+
+func (dirtySetFunctions) Merge(_ memmap.FileRange, _ dirtySetValue, _ memmap.FileRange, _ dirtySetValue) (dirtySetValue, bool) {
+	return dirtySetValue{}, true
+}
+
+func (dirtySetFunctions) Split(_ memmap.FileRange, _ dirtySetValue, _ uint64) (dirtySetValue, dirtySetValue) {
+	return dirtySetValue{}, dirtySetValue{}
+}
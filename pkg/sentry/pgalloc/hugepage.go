@@ -0,0 +1,126 @@
+// Copyright 2021 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pgalloc
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"os"
+	"strconv"
+
+	"gvisor.dev/gvisor/pkg/metric"
+	"gvisor.dev/gvisor/pkg/sync"
+)
+
+func init() {
+	metric.MustRegisterCustomUint64Metric("/memory/hugepage_bytes", false /* cumulative */, false /* sync */, "Bytes of sandbox memory backed by host hugepages, if hugepages are enabled.", hugepageBytes)
+}
+
+// hugepageFiles tracks MemoryFiles that use hugepages, for hugepageBytes.
+var hugepageFiles struct {
+	mu sync.Mutex
+
+	// hugetlb contains MemoryFiles backed by hugetlbfs.
+	hugetlb map[*MemoryFile]struct{}
+
+	// smapsRollup is the host's /proc/self/smaps_rollup, which is opened
+	// when the first MemoryFile that uses transparent hugepages is created,
+	// before the sentry's syscall filters prevent opening it.
+	smapsRollup *os.File
+}
+
+// registerHugepageFile records that f's hugepage usage is reported by
+// hugepageBytes, if f uses hugepages.
+func registerHugepageFile(f *MemoryFile) error {
+	hugepageFiles.mu.Lock()
+	defer hugepageFiles.mu.Unlock()
+	if f.opts.HugepageSize != 0 {
+		if hugepageFiles.hugetlb == nil {
+			hugepageFiles.hugetlb = make(map[*MemoryFile]struct{})
+		}
+		hugepageFiles.hugetlb[f] = struct{}{}
+		return nil
+	}
+	if f.opts.AdviseHugepage && hugepageFiles.smapsRollup == nil {
+		smaps, err := os.Open("/proc/self/smaps_rollup")
+		if err != nil {
+			return err
+		}
+		hugepageFiles.smapsRollup = smaps
+	}
+	return nil
+}
+
+// unregisterHugepageFile undoes registerHugepageFile.
+func unregisterHugepageFile(f *MemoryFile) {
+	hugepageFiles.mu.Lock()
+	defer hugepageFiles.mu.Unlock()
+	delete(hugepageFiles.hugetlb, f)
+}
+
+// hugepageBytes returns the number of bytes of MemoryFiles that are backed by
+// hugepages. All committed pages of hugetlbfs files are hugepages. For other
+// files, the host reports the amount of shared memory that the sentry maps
+// with transparent hugepages.
+func hugepageBytes() uint64 {
+	// MemoryFile.mu may be locked when calling unregisterHugepageFile, so it
+	// can't be locked with hugepageFiles.mu.
+	hugepageFiles.mu.Lock()
+	hugetlb := make([]*MemoryFile, 0, len(hugepageFiles.hugetlb))
+	for f := range hugepageFiles.hugetlb {
+		hugetlb = append(hugetlb, f)
+	}
+	smaps := hugepageFiles.smapsRollup
+	hugepageFiles.mu.Unlock()
+
+	var total uint64
+	for _, f := range hugetlb {
+		f.mu.Lock()
+		total += f.usageExpected
+		f.mu.Unlock()
+	}
+	if smaps != nil {
+		// smaps_rollup is regenerated when read from offset 0.
+		kb, err := shmemPmdMappedKB(io.NewSectionReader(smaps, 0, 1<<20))
+		if err == nil {
+			total += kb << 10
+		}
+	}
+	return total
+}
+
+// shmemPmdMappedKB returns the value of the ShmemPmdMapped field of the
+// smaps_rollup file read from r.
+func shmemPmdMappedKB(r io.Reader) (uint64, error) {
+	prefix := []byte("ShmemPmdMapped:")
+	s := bufio.NewScanner(r)
+	for s.Scan() {
+		line := s.Bytes()
+		if !bytes.HasPrefix(line, prefix) {
+			continue
+		}
+		// e.g. "ShmemPmdMapped:     4096 kB"
+		fields := bytes.Fields(line[len(prefix):])
+		if len(fields) == 0 {
+			break
+		}
+		return strconv.ParseUint(string(fields[0]), 10, 64)
+	}
+	if err := s.Err(); err != nil {
+		return 0, err
+	}
+	return 0, io.ErrUnexpectedEOF
+}
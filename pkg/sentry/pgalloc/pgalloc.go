@@ -19,6 +19,7 @@
 //
 // pgalloc.MemoryFile.mu
 //   pgalloc.MemoryFile.mappingsMu
+//   pgalloc.hugepageFiles.mu
 package pgalloc
 
 import (
@@ -161,6 +162,24 @@ type MemoryFile struct {
 	// notifications used to drive eviction. stopNotifyPressure is
 	// immutable.
 	stopNotifyPressure func()
+
+	// dirtyTracking is non-zero if StartDirtyTracking has been called.
+	// dirtyTracking is accessed using atomic memory operations.
+	dirtyTracking uint32
+
+	// dirtyMu protects the following fields. dirtyMu is ordered after mu.
+	dirtyMu sync.Mutex
+
+	// writeTracker reports writes that f can't observe itself.
+	writeTracker WriteTracker
+
+	// dirty contains pages that may have been written since the last call to
+	// SaveTo, SaveDirtyTo or SaveLiveTo.
+	dirty dirtySet
+
+	// dirtyBaseline is true if SaveTo, or SaveLiveTo with full set, has been
+	// called since dirty tracking was enabled.
+	dirtyBaseline bool
 }
 
 // MemoryFileOpts provides options to NewMemoryFile.
@@ -178,6 +197,17 @@ type MemoryFileOpts struct {
 	// obtained from the host are zero-filled, such that MemoryFile must manually
 	// zero newly-allocated pages.
 	ManualZeroing bool
+
+	// If AdviseHugepage is true, MemoryFile advises the host to back its
+	// internal mappings of the file with transparent hugepages. Whether the
+	// host does so depends on its shmem_enabled setting.
+	AdviseHugepage bool
+
+	// If HugepageSize is not zero, the file is a hugetlbfs file whose pages
+	// are of this size, which must be a power of 2 between the page size and
+	// 1 GB. The host can only decommit whole hugepages; partially
+	// decommitted hugepages are zeroed instead.
+	HugepageSize uint64
 }
 
 // DelayedEvictionType is the type of MemoryFileOpts.DelayedEviction.
@@ -300,6 +330,9 @@ func NewMemoryFile(file *os.File, opts MemoryFileOpts) (*MemoryFile, error) {
 	default:
 		return nil, fmt.Errorf("invalid MemoryFileOpts.DelayedEviction: %v", opts.DelayedEviction)
 	}
+	if hps := opts.HugepageSize; hps != 0 && (hps&(hps-1) != 0 || hps < usermem.PageSize || hps > chunkSize) {
+		return nil, fmt.Errorf("invalid MemoryFileOpts.HugepageSize: %#x", hps)
+	}
 
 	// Truncate the file to 0 bytes first to ensure that it's empty.
 	if err := file.Truncate(0); err != nil {
@@ -330,6 +363,11 @@ func NewMemoryFile(file *os.File, opts MemoryFileOpts) (*MemoryFile, error) {
 
 	go f.runReclaim() // S/R-SAFE: f.mu
 
+	if err := registerHugepageFile(f); err != nil {
+		// This only affects metrics.
+		log.Warningf("Failed to set up hugepage usage reporting: %v", err)
+	}
+
 	// The Linux kernel contains an optional feature called "Integrity
 	// Measurement Architecture" (IMA). If IMA is enabled, it will checksum
 	// binaries the first time they are mapped PROT_EXEC. This is bad news for
@@ -341,10 +379,16 @@ func NewMemoryFile(file *os.File, opts MemoryFileOpts) (*MemoryFile, error) {
 	// Work around IMA by immediately creating a temporary PROT_EXEC mapping,
 	// while the backing file is still small. IMA will ignore any future
 	// mappings.
+	//
+	// Mappings of hugetlbfs files must be unmapped in units of hugepages.
+	premapSize := uintptr(usermem.PageSize)
+	if opts.HugepageSize != 0 {
+		premapSize = uintptr(opts.HugepageSize)
+	}
 	m, _, errno := syscall.Syscall6(
 		syscall.SYS_MMAP,
 		0,
-		usermem.PageSize,
+		premapSize,
 		syscall.PROT_EXEC,
 		syscall.MAP_SHARED,
 		file.Fd(),
@@ -357,7 +401,7 @@ func NewMemoryFile(file *os.File, opts MemoryFileOpts) (*MemoryFile, error) {
 		if _, _, errno := syscall.Syscall(
 			syscall.SYS_MUNMAP,
 			m,
-			usermem.PageSize,
+			premapSize,
 			0); errno != 0 {
 			panic(fmt.Sprintf("failed to unmap PROT_EXEC MemoryFile mapping: %v", errno))
 		}
@@ -399,6 +443,9 @@ func (f *MemoryFile) Allocate(length uint64, kind usage.MemoryKind) (memmap.File
 	if length >= usermem.HugePageSize {
 		alignment = usermem.HugePageSize
 	}
+	if hps := f.opts.HugepageSize; hps > alignment && length >= hps {
+		alignment = hps
+	}
 
 	// Find a range in the underlying file.
 	fr, ok := findAvailableRange(&f.usage, f.fileSize, length, alignment)
@@ -581,6 +628,29 @@ func (f *MemoryFile) manuallyZero(fr memmap.FileRange) error {
 }
 
 func (f *MemoryFile) decommitFile(fr memmap.FileRange) error {
+	if hps := f.opts.HugepageSize; hps != 0 {
+		// hugetlbfs only frees whole hugepages, and before Linux 6.2 doesn't
+		// zero the remainder of hugepages that are partially covered by a
+		// hole. Zero the partial hugepages at the ends of fr ourselves.
+		whole := memmap.FileRange{
+			Start: (fr.Start + hps - 1) &^ (hps - 1),
+			End:   fr.End &^ (hps - 1),
+		}
+		if whole.Start >= whole.End {
+			return f.manuallyZero(fr)
+		}
+		if fr.Start < whole.Start {
+			if err := f.manuallyZero(memmap.FileRange{fr.Start, whole.Start}); err != nil {
+				return err
+			}
+		}
+		if whole.End < fr.End {
+			if err := f.manuallyZero(memmap.FileRange{whole.End, fr.End}); err != nil {
+				return err
+			}
+		}
+		fr = whole
+	}
 	// "After a successful call, subsequent reads from this range will
 	// return zeroes. The FALLOC_FL_PUNCH_HOLE flag must be ORed with
 	// FALLOC_FL_KEEP_SIZE in mode ..." - fallocate(2)
@@ -594,6 +664,8 @@ func (f *MemoryFile) decommitFile(fr memmap.FileRange) error {
 func (f *MemoryFile) markDecommitted(fr memmap.FileRange) {
 	f.mu.Lock()
 	defer f.mu.Unlock()
+	// Decommitted pages read as zeroes, which may not be their saved contents.
+	f.MarkDirty(fr)
 	// Since we're changing the knownCommitted attribute, we need to merge
 	// across the entire range to ensure that the usage tree is minimal.
 	gap := f.usage.ApplyContiguous(fr, func(seg usageIterator) {
@@ -676,6 +748,9 @@ func (f *MemoryFile) MapInternal(fr memmap.FileRange, at usermem.AccessType) (sa
 	if at.Execute {
 		return safemem.BlockSeq{}, syserror.EACCES
 	}
+	if at.Write {
+		f.MarkDirty(fr)
+	}
 
 	chunks := ((fr.End + chunkMask) >> chunkShift) - (fr.Start >> chunkShift)
 	if chunks == 1 {
@@ -741,6 +816,16 @@ func (f *MemoryFile) getChunkMapping(chunk int) ([]uintptr, uintptr, error) {
 	if errno != 0 {
 		return nil, 0, errno
 	}
+	if f.opts.AdviseHugepage {
+		if _, _, errno := syscall.Syscall(
+			syscall.SYS_MADVISE,
+			m,
+			chunkSize,
+			linux.MADV_HUGEPAGE); errno != 0 {
+			// This only affects performance.
+			log.Warningf("Failed to advise hugepages for MemoryFile chunk %d: %v", chunk, errno)
+		}
+	}
 	atomic.StoreUintptr(&mappings[chunk], m)
 	return mappings, m, nil
 }
@@ -1084,6 +1169,7 @@ func (f *MemoryFile) runReclaim() {
 		f.mu.Unlock()
 		panic("findReclaimable broke out of reclaim loop, but destroyed is no longer set")
 	}
+	unregisterHugepageFile(f)
 	f.file.Close()
 	// Ensure that any attempts to use f.file.Fd() fail instead of getting a fd
 	// that has possibly been reassigned.
@@ -15,8 +15,15 @@
 package pgalloc
 
 import (
+	"bytes"
+	"context"
+	"os"
 	"testing"
 
+	"gvisor.dev/gvisor/pkg/memutil"
+	"gvisor.dev/gvisor/pkg/safemem"
+	"gvisor.dev/gvisor/pkg/sentry/memmap"
+	"gvisor.dev/gvisor/pkg/sentry/usage"
 	"gvisor.dev/gvisor/pkg/usermem"
 )
 
@@ -244,3 +251,198 @@ func TestFindUnallocatedRange(t *testing.T) {
 		})
 	}
 }
+
+func newTestMemoryFile(t *testing.T, opts MemoryFileOpts) *MemoryFile {
+	t.Helper()
+	fd, err := memutil.CreateMemFD("pgalloc-test", 0)
+	if err != nil {
+		t.Fatalf("CreateMemFD failed: %v", err)
+	}
+	file := os.NewFile(uintptr(fd), "pgalloc-test")
+	mf, err := NewMemoryFile(file, opts)
+	if err != nil {
+		file.Close()
+		t.Fatalf("NewMemoryFile failed: %v", err)
+	}
+	return mf
+}
+
+func fillRange(t *testing.T, mf *MemoryFile, fr memmap.FileRange, b byte) {
+	t.Helper()
+	dsts, err := mf.MapInternal(fr, usermem.Write)
+	if err != nil {
+		t.Fatalf("MapInternal(%v) failed: %v", fr, err)
+	}
+	src := bytes.Repeat([]byte{b}, int(fr.Length()))
+	if _, err := safemem.CopySeq(dsts, safemem.BlockSeqOf(safemem.BlockFromSafeSlice(src))); err != nil {
+		t.Fatalf("CopySeq failed: %v", err)
+	}
+}
+
+func checkRange(t *testing.T, mf *MemoryFile, fr memmap.FileRange, b byte) {
+	t.Helper()
+	want := bytes.Repeat([]byte{b}, int(fr.Length()))
+	var got []byte
+	if err := mf.forEachMappingSlice(fr, func(s []byte) {
+		got = append(got, s...)
+	}); err != nil {
+		t.Fatalf("forEachMappingSlice(%v) failed: %v", fr, err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("contents of %v: got %q..., want %q...", fr, got[:8], want[:8])
+	}
+}
+
+func TestSaveDirty(t *testing.T) {
+	ctx := context.Background()
+	src := newTestMemoryFile(t, MemoryFileOpts{})
+	defer src.Destroy()
+	dst := newTestMemoryFile(t, MemoryFileOpts{})
+	defer dst.Destroy()
+	src.StartDirtyTracking(nil)
+
+	fr1, err := src.Allocate(2*page, usage.Anonymous)
+	if err != nil {
+		t.Fatalf("Allocate failed: %v", err)
+	}
+	fillRange(t, src, fr1, 'a')
+	fr2, err := src.Allocate(page, usage.Anonymous)
+	if err != nil {
+		t.Fatalf("Allocate failed: %v", err)
+	}
+	fillRange(t, src, fr2, 'b')
+
+	// SaveDirtyTo requires a full save first.
+	var buf bytes.Buffer
+	if err := src.SaveDirtyTo(ctx, &buf); err == nil {
+		t.Fatalf("SaveDirtyTo before SaveTo succeeded")
+	}
+	buf.Reset()
+	if err := src.SaveTo(ctx, &buf); err != nil {
+		t.Fatalf("SaveTo failed: %v", err)
+	}
+	if err := dst.LoadFrom(ctx, &buf); err != nil {
+		t.Fatalf("LoadFrom failed: %v", err)
+	}
+
+	// Change one page and free another.
+	fillRange(t, src, memmap.FileRange{fr1.Start, fr1.Start + page}, 'c')
+	src.DecRef(fr2)
+
+	buf.Reset()
+	if err := src.SaveDirtyTo(ctx, &buf); err != nil {
+		t.Fatalf("SaveDirtyTo failed: %v", err)
+	}
+	if buf.Len() >= 2*page {
+		t.Errorf("SaveDirtyTo wrote %d bytes, want less than %d", buf.Len(), 2*page)
+	}
+	if err := dst.LoadDirtyFrom(ctx, &buf); err != nil {
+		t.Fatalf("LoadDirtyFrom failed: %v", err)
+	}
+	checkRange(t, dst, memmap.FileRange{fr1.Start, fr1.Start + page}, 'c')
+	checkRange(t, dst, memmap.FileRange{fr1.Start + page, fr1.End}, 'a')
+	checkRange(t, dst, fr2, 0)
+	if seg := dst.usage.FindSegment(fr2.Start); seg.Ok() {
+		t.Errorf("freed pages %v are still allocated after LoadDirtyFrom", fr2)
+	}
+}
+
+func TestSaveLive(t *testing.T) {
+	ctx := context.Background()
+	src := newTestMemoryFile(t, MemoryFileOpts{})
+	defer src.Destroy()
+	dst := newTestMemoryFile(t, MemoryFileOpts{})
+	defer dst.Destroy()
+	src.StartDirtyTracking(nil)
+
+	fr, err := src.Allocate(4*page, usage.Anonymous)
+	if err != nil {
+		t.Fatalf("Allocate failed: %v", err)
+	}
+	fillRange(t, src, fr, 'a')
+
+	// An incremental live save requires a full one first.
+	var buf bytes.Buffer
+	if _, err := src.SaveLiveTo(ctx, &buf, false /* full */); err == nil {
+		t.Fatalf("incremental SaveLiveTo before full SaveLiveTo succeeded")
+	}
+	buf.Reset()
+	n, err := src.SaveLiveTo(ctx, &buf, true /* full */)
+	if err != nil {
+		t.Fatalf("SaveLiveTo failed: %v", err)
+	}
+	if n != fr.Length() {
+		t.Errorf("SaveLiveTo saved %d bytes, want %d", n, fr.Length())
+	}
+	if err := dst.LoadDirtyFrom(ctx, &buf); err != nil {
+		t.Fatalf("LoadDirtyFrom failed: %v", err)
+	}
+	checkRange(t, dst, fr, 'a')
+
+	// Only the written page is reported and saved by the next increment.
+	written := memmap.FileRange{fr.Start + page, fr.Start + 2*page}
+	fillRange(t, src, written, 'b')
+	if got, err := src.DirtyBytes(); err != nil || got != page {
+		t.Errorf("DirtyBytes: got (%d, %v), want (%d, nil)", got, err, page)
+	}
+	buf.Reset()
+	if n, err := src.SaveLiveTo(ctx, &buf, false /* full */); err != nil || n != page {
+		t.Fatalf("SaveLiveTo: got (%d, %v), want (%d, nil)", n, err, page)
+	}
+	if err := dst.LoadDirtyFrom(ctx, &buf); err != nil {
+		t.Fatalf("LoadDirtyFrom failed: %v", err)
+	}
+
+	// The final increment is written with writers stopped.
+	buf.Reset()
+	if err := src.SaveDirtyTo(ctx, &buf); err != nil {
+		t.Fatalf("SaveDirtyTo failed: %v", err)
+	}
+	if err := dst.LoadDirtyFrom(ctx, &buf); err != nil {
+		t.Fatalf("LoadDirtyFrom failed: %v", err)
+	}
+	checkRange(t, dst, memmap.FileRange{fr.Start, written.Start}, 'a')
+	checkRange(t, dst, written, 'b')
+	checkRange(t, dst, memmap.FileRange{written.End, fr.End}, 'a')
+}
+
+func TestHugepageDecommit(t *testing.T) {
+	// The test file isn't on hugetlbfs, but HugepageSize still determines
+	// which parts of decommitted ranges are zeroed manually.
+	mf := newTestMemoryFile(t, MemoryFileOpts{HugepageSize: hugepage})
+	fr, err := mf.Allocate(2*hugepage, usage.Anonymous)
+	if err != nil {
+		t.Fatalf("Allocate failed: %v", err)
+	}
+	if fr.Start%hugepage != 0 {
+		t.Fatalf("Allocate returned %v, which isn't hugepage-aligned", fr)
+	}
+	fillRange(t, mf, fr, 'a')
+
+	// Decommit a range covering one whole hugepage and part of another.
+	dfr := memmap.FileRange{fr.Start + page, fr.End}
+	if err := mf.Decommit(dfr); err != nil {
+		t.Fatalf("Decommit(%v) failed: %v", dfr, err)
+	}
+	checkRange(t, mf, memmap.FileRange{fr.Start, fr.Start + page}, 'a')
+	checkRange(t, mf, dfr, 0)
+}
+
+func TestShmemPmdMappedKB(t *testing.T) {
+	const smaps = `55d5f6a00000-7ffd2b1fe000 ---p 00000000 00:00 0                          [rollup]
+Rss:              123456 kB
+Shmem_Hugepages:       0 kB
+ShmemPmdMapped:     4096 kB
+FilePmdMapped:         0 kB
+`
+	kb, err := shmemPmdMappedKB(bytes.NewBufferString(smaps))
+	if err != nil {
+		t.Fatalf("shmemPmdMappedKB failed: %v", err)
+	}
+	if kb != 4096 {
+		t.Errorf("shmemPmdMappedKB = %d, want 4096", kb)
+	}
+	if _, err := shmemPmdMappedKB(bytes.NewBufferString("Rss: 1 kB\n")); err == nil {
+		t.Errorf("shmemPmdMappedKB succeeded without a ShmemPmdMapped field")
+	}
+}
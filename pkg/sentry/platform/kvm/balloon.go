@@ -0,0 +1,90 @@
+// Copyright 2020 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kvm
+
+import (
+	"runtime/debug"
+	"sync/atomic"
+	"time"
+
+	"gvisor.dev/gvisor/pkg/log"
+)
+
+// StartIdleReclaim starts a goroutine that returns memory to the host once
+// application code has not run on any of the machine's vCPUs for the given
+// period. This allows memory that was used while the sandbox was busy to be
+// reclaimed by the host while it is idle, without the host having to apply
+// memory pressure first.
+//
+// When the machine becomes idle, reclaim is called to release memory held on
+// behalf of applications (e.g. by evicting caches), after which memory freed
+// by the sentry's Go heap is returned to the host with MADV_DONTNEED. This
+// happens at most once per idle period; the machine must run application code
+// again before reclaim is called again.
+//
+// StartIdleReclaim may be called at most once.
+func (k *KVM) StartIdleReclaim(period time.Duration, reclaim func()) {
+	go k.machine.idleReclaimLoop(period, reclaim) // S/R-SAFE: not saved.
+}
+
+func (m *machine) idleReclaimLoop(period time.Duration, reclaim func()) {
+	ticker := time.NewTicker(period)
+	defer ticker.Stop()
+
+	lastExits := m.userExits()
+	reclaimed := false
+	for range ticker.C {
+		exits, busy := m.userExits(), m.inUser()
+		if busy || exits != lastExits {
+			lastExits = exits
+			reclaimed = false
+			continue
+		}
+		if reclaimed {
+			continue
+		}
+		log.Debugf("KVM machine idle for %v, returning memory to the host", period)
+		reclaim()
+		debug.FreeOSMemory()
+		reclaimed = true
+	}
+}
+
+// userExits returns the total number of user exits taken by the machine's
+// vCPUs. It increases whenever application code runs.
+func (m *machine) userExits() uint64 {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	var n uint64
+	for _, c := range m.vCPUsByID {
+		if c != nil {
+			n += atomic.LoadUint64(&c.userExits)
+		}
+	}
+	return n
+}
+
+// inUser returns true if any of the machine's vCPUs is in, or about to enter,
+// user mode.
+func (m *machine) inUser() bool {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	for _, c := range m.vCPUsByID {
+		if c != nil && atomic.LoadUint32(&c.state)&vCPUUser != 0 {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,105 @@
+// Copyright 2020 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kvm
+
+import (
+	"os"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	pkgcontext "gvisor.dev/gvisor/pkg/context"
+	"gvisor.dev/gvisor/pkg/memutil"
+	"gvisor.dev/gvisor/pkg/sentry/pgalloc"
+	"gvisor.dev/gvisor/pkg/usermem"
+)
+
+// testEvictable is a pgalloc.EvictableMemoryUser that reports evictions.
+type testEvictable struct {
+	evicted chan pgalloc.EvictableRange
+}
+
+// Evict implements pgalloc.EvictableMemoryUser.Evict.
+func (e *testEvictable) Evict(_ pkgcontext.Context, er pgalloc.EvictableRange) {
+	e.evicted <- er
+}
+
+func TestIdleReclaim(t *testing.T) {
+	deviceFile, err := OpenDevice()
+	if err != nil {
+		t.Fatalf("error opening device file: %v", err)
+	}
+	k, err := New(deviceFile)
+	if err != nil {
+		t.Fatalf("error creating KVM instance: %v", err)
+	}
+	defer k.machine.Destroy()
+
+	fd, err := memutil.CreateMemFD("kvm-test", 0)
+	if err != nil {
+		t.Fatalf("CreateMemFD failed: %v", err)
+	}
+	mf, err := pgalloc.NewMemoryFile(os.NewFile(uintptr(fd), "kvm-test"), pgalloc.MemoryFileOpts{
+		// Only evict when reclaim asks to.
+		DelayedEviction: pgalloc.DelayedEvictionManual,
+	})
+	if err != nil {
+		t.Fatalf("NewMemoryFile failed: %v", err)
+	}
+	defer mf.Destroy()
+	user := &testEvictable{evicted: make(chan pgalloc.EvictableRange, 1)}
+	er := pgalloc.EvictableRange{Start: 0, End: usermem.PageSize}
+	mf.MarkEvictable(user, er)
+
+	// Reclaim as runsc does, by evicting the memory file's evictable
+	// allocations.
+	const period = 10 * time.Millisecond
+	reclaims := make(chan struct{}, 1)
+	k.StartIdleReclaim(period, func() {
+		mf.StartEvictions()
+		reclaims <- struct{}{}
+	})
+
+	// No application code runs, so the machine is idle.
+	select {
+	case got := <-user.evicted:
+		if got != er {
+			t.Errorf("evicted range got %v, want %v", got, er)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatalf("idle machine didn't start evictions")
+	}
+	<-reclaims
+
+	// Reclaim happens once per idle period.
+	mf.MarkEvictable(user, er)
+	select {
+	case <-reclaims:
+		t.Fatalf("idle machine reclaimed memory twice")
+	case <-time.After(20 * period):
+	}
+
+	// Once application code runs again, the next idle period reclaims
+	// memory again.
+	c := k.machine.Get()
+	atomic.AddUint64(&c.userExits, 1) // As in context.Switch.
+	k.machine.Put(c)
+	select {
+	case <-user.evicted:
+	case <-time.After(10 * time.Second):
+		t.Fatalf("machine idle again didn't start evictions")
+	}
+	<-reclaims
+}
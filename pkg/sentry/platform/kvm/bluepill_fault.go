@@ -96,8 +96,17 @@ func handleBluepillFault(m *machine, physical uintptr, phyRegions []physicalRegi
 		yield() // Race with another call.
 		slot = atomic.SwapUint32(&m.nextSlot, ^uint32(0))
 	}
+	if flags&_KVM_MEM_READONLY == 0 && atomic.LoadUint32(&m.dirtyLogging) != 0 {
+		flags |= _KVM_MEM_LOG_DIRTY_PAGES
+	}
 	errno := m.setMemoryRegion(int(slot), physicalStart, length, virtualStart, flags)
 	if errno == 0 {
+		m.slots[slot] = memorySlot{
+			physical: physicalStart,
+			length:   length,
+			virtual:  virtualStart,
+			flags:    flags,
+		}
 		// Store the physical address in the slot. This is used to
 		// avoid calls to handleBluepillFault in the future (see
 		// machine.mapPhysical).
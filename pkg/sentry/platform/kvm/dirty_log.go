@@ -0,0 +1,124 @@
+// Copyright 2020 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kvm
+
+import (
+	"fmt"
+	"math/bits"
+	"sync/atomic"
+
+	"gvisor.dev/gvisor/pkg/usermem"
+)
+
+// memorySlot is a memory region installed in a KVM memory slot.
+type memorySlot struct {
+	physical uintptr
+	length   uintptr
+	virtual  uintptr
+	flags    uint32
+}
+
+// lockSlots acquires the exclusive right to change memory slots, and returns
+// the number of slots in use. See machine.nextSlot.
+func (m *machine) lockSlots() uint32 {
+	slot := atomic.SwapUint32(&m.nextSlot, ^uint32(0))
+	for slot == ^uint32(0) {
+		yield() // Race with handleBluepillFault.
+		slot = atomic.SwapUint32(&m.nextSlot, ^uint32(0))
+	}
+	return slot
+}
+
+// unlockSlots releases the lock acquired by lockSlots.
+func (m *machine) unlockSlots(slots uint32) {
+	atomic.StoreUint32(&m.nextSlot, slots)
+}
+
+// EnableDirtyLogging causes KVM to log writes by the guest, i.e. by
+// application code and by the sentry while running in guest mode, to all
+// writable memory, including memory that is mapped into the VM later. Logged
+// writes are reported by CollectDirty.
+//
+// Writes made by the sentry while running in host mode are not logged.
+func (k *KVM) EnableDirtyLogging() error {
+	m := k.machine
+	n := m.lockSlots()
+	defer m.unlockSlots(n)
+	if atomic.LoadUint32(&m.dirtyLogging) != 0 {
+		return nil
+	}
+	for i := uint32(0); i < n; i++ {
+		s := &m.slots[i]
+		if s.flags&(_KVM_MEM_READONLY|_KVM_MEM_LOG_DIRTY_PAGES) != 0 {
+			continue
+		}
+		flags := s.flags | _KVM_MEM_LOG_DIRTY_PAGES
+		if errno := m.setMemoryRegion(int(i), s.physical, s.length, s.virtual, flags); errno != 0 {
+			return fmt.Errorf("enabling dirty logging for slot %d: %v", i, errno)
+		}
+		s.flags = flags
+	}
+	atomic.StoreUint32(&m.dirtyLogging, 1)
+	return nil
+}
+
+// CollectDirty implements pgalloc.WriteTracker.CollectDirty. It reports the
+// host virtual addresses of pages written by the guest since the previous
+// call to CollectDirty, or since EnableDirtyLogging if there was none.
+func (k *KVM) CollectDirty(fn func(addr, length uintptr)) error {
+	m := k.machine
+	n := m.lockSlots()
+	slots := make([]memorySlot, n)
+	copy(slots, m.slots[:n])
+	m.unlockSlots(n)
+
+	var bitmap []uint64
+	for i, s := range slots {
+		if s.flags&_KVM_MEM_LOG_DIRTY_PAGES == 0 {
+			continue
+		}
+		pages := s.length / usermem.PageSize
+		words := int((pages + 63) / 64)
+		if cap(bitmap) < words {
+			bitmap = make([]uint64, words)
+		}
+		bitmap = bitmap[:words]
+		if err := m.getDirtyLog(i, bitmap); err != nil {
+			return err
+		}
+
+		// Report runs of dirty pages.
+		var runStart, runLen uintptr
+		for w, word := range bitmap {
+			for word != 0 {
+				b := bits.TrailingZeros64(word)
+				word &^= 1 << uint(b)
+				addr := s.virtual + uintptr(w*64+b)*usermem.PageSize
+				if runLen != 0 && runStart+runLen == addr {
+					runLen += usermem.PageSize
+					continue
+				}
+				if runLen != 0 {
+					fn(runStart, runLen)
+				}
+				runStart, runLen = addr, usermem.PageSize
+			}
+		}
+		if runLen != 0 {
+			fn(runStart, runLen)
+		}
+	}
+	return nil
+}
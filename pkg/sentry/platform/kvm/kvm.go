@@ -38,6 +38,15 @@ type userMemoryRegion struct {
 	userspaceAddr uint64
 }
 
+// dirtyLog is the argument to KVM_GET_DIRTY_LOG.
+//
+// This mirrors kvm_dirty_log.
+type dirtyLog struct {
+	slot   uint32
+	_      uint32
+	bitmap uint64
+}
+
 // runData is the run structure. This may be mapped for synchronous register
 // access (although that doesn't appear to be supported by my kernel at least).
 //
@@ -187,7 +196,11 @@ func (*constructor) OpenDevice() (*os.File, error) {
 
 // Flags implements platform.Constructor.Flags().
 func (*constructor) Requirements() platform.Requirements {
-	return platform.Requirements{}
+	// TODO(b/151157106): syscall tests fail by timeout if asyncpreemptoff
+	// isn't set.
+	return platform.Requirements{
+		DisableAsyncPreemption: true,
+	}
 }
 
 func init() {
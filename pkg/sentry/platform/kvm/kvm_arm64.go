@@ -17,6 +17,7 @@
 package kvm
 
 import (
+	"gvisor.dev/gvisor/pkg/cpuid"
 	"gvisor.dev/gvisor/pkg/sentry/arch"
 	"gvisor.dev/gvisor/pkg/sentry/platform/ring0"
 )
@@ -59,6 +60,14 @@ type kvmVcpuEvents struct {
 	rsvd [12]uint32
 }
 
+// FilterFeatureSet removes features that are unavailable to application code
+// running in the machine from fs.
+func (*KVM) FilterFeatureSet(fs *cpuid.FeatureSet) {
+	// vCPUs are not initialized with KVM_ARM_VCPU_SVE, so SVE instructions
+	// trap, and SVE register state would not be preserved by the switch.
+	fs.Remove(cpuid.ARM64FeatureSVE)
+}
+
 // updateGlobalOnce does global initialization. It has to be called only once.
 func updateGlobalOnce(fd int) error {
 	physicalInit()
@@ -31,6 +31,7 @@ const (
 	_KVM_INTERRUPT              = 0x4004ae86
 	_KVM_SET_MSRS               = 0x4008ae89
 	_KVM_SET_USER_MEMORY_REGION = 0x4020ae46
+	_KVM_GET_DIRTY_LOG          = 0x4010ae42
 	_KVM_SET_REGS               = 0x4090ae82
 	_KVM_SET_SREGS              = 0x4138ae84
 	_KVM_GET_MSRS               = 0xc008ae88
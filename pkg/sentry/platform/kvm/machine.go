@@ -70,6 +70,16 @@ type machine struct {
 	// usedSlots is the set of used physical addresses (sorted).
 	usedSlots []uintptr
 
+	// slots records the memory region installed in each used slot, so that
+	// the regions can be updated when dirty logging is enabled. Entries below
+	// nextSlot are valid, and are protected by the nextSlot protocol.
+	slots []memorySlot
+
+	// dirtyLogging is non-zero if writes to writable slots are being logged.
+	// It is accessed atomically, and only changed while slots are locked (see
+	// nextSlot).
+	dirtyLogging uint32
+
 	// nextID is the next vCPU ID.
 	nextID uint32
 }
@@ -213,6 +223,7 @@ func newMachine(vm int) (*machine, error) {
 	}
 	log.Debugf("The maximum number of slots is %d.", m.maxSlots)
 	m.usedSlots = make([]uintptr, m.maxSlots)
+	m.slots = make([]memorySlot, m.maxSlots)
 
 	// Create the upper shared pagetables and kernel(sentry) pagetables.
 	m.upperSharedPageTables = pagetables.New(newAllocator())
@@ -59,6 +59,22 @@ func (m *machine) setMemoryRegion(slot int, physical, length, virtual uintptr, f
 	return errno
 }
 
+// getDirtyLog retrieves, and clears, the dirty page bitmap of the given slot.
+func (m *machine) getDirtyLog(slot int, bitmap []uint64) error {
+	dl := dirtyLog{
+		slot:   uint32(slot),
+		bitmap: uint64(uintptr(unsafe.Pointer(&bitmap[0]))),
+	}
+	if _, _, errno := syscall.RawSyscall(
+		syscall.SYS_IOCTL,
+		uintptr(m.fd),
+		_KVM_GET_DIRTY_LOG,
+		uintptr(unsafe.Pointer(&dl))); errno != 0 {
+		return fmt.Errorf("getting dirty log for slot %d: %v", slot, errno)
+	}
+	return nil
+}
+
 // mapRunData maps the vCPU run data.
 func mapRunData(fd int) (*runData, error) {
 	r, _, errno := syscall.RawSyscall6(
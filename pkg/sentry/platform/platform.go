@@ -20,6 +20,7 @@ package platform
 import (
 	"fmt"
 	"os"
+	"sort"
 
 	"gvisor.dev/gvisor/pkg/abi/linux"
 	"gvisor.dev/gvisor/pkg/context"
@@ -422,9 +423,21 @@ type Requirements struct {
 	// RequiresCapSysPtrace indicates that the sandbox has to be started with
 	// the CAP_SYS_PTRACE capability.
 	RequiresCapSysPtrace bool
+	// RequiresReadableExecutable indicates that the runsc binary must be
+	// readable by other users, since the sandbox runs as a different user
+	// and processes with unreadable executables can't be ptraced.
+	RequiresReadableExecutable bool
+	// DisableAsyncPreemption indicates that the sandbox has to be started
+	// with Go's asynchronous preemption disabled.
+	DisableAsyncPreemption bool
 }
 
 // Constructor represents a platform type.
+//
+// Platforms, including those maintained outside of this repository, make
+// themselves available by calling Register from an init function. A runsc
+// binary that includes such a platform can be built by importing its package
+// alongside runsc/cli, and calling cli.Main.
 type Constructor interface {
 	// New returns a new platform instance.
 	//
@@ -432,6 +445,13 @@ type Constructor interface {
 	//
 	// * deviceFile - the device file (e.g. /dev/kvm for the KVM platform).
 	New(deviceFile *os.File) (Platform, error)
+
+	// OpenDevice returns the device file passed to New, or nil if the
+	// platform doesn't need one.
+	//
+	// OpenDevice is called by runsc outside of the sandbox, with the
+	// privileges of the runtime; New is called inside the sandbox, after
+	// the file has been passed to it.
 	OpenDevice() (*os.File, error)
 
 	// Requirements returns platform specific requirements.
@@ -441,16 +461,35 @@ type Constructor interface {
 // platforms contains all available platform types.
 var platforms = map[string]Constructor{}
 
-// Register registers a new platform type.
+// Register registers a new platform type. It must be called before runsc
+// parses its flags, i.e. from an init function.
+//
+// Register panics if a platform with the same name is already registered.
 func Register(name string, platform Constructor) {
+	if name == "" {
+		panic("platform registered without a name")
+	}
+	if _, ok := platforms[name]; ok {
+		panic(fmt.Sprintf("platform %q registered twice", name))
+	}
 	platforms[name] = platform
 }
 
+// List returns the names of all registered platforms, in sorted order.
+func List() []string {
+	names := make([]string, 0, len(platforms))
+	for name := range platforms {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
 // Lookup looks up the platform constructor by name.
 func Lookup(name string) (Constructor, error) {
 	p, ok := platforms[name]
 	if !ok {
-		return nil, fmt.Errorf("unknown platform: %v", name)
+		return nil, fmt.Errorf("unknown platform: %v (available platforms: %v)", name, List())
 	}
 	return p, nil
 }
@@ -0,0 +1,94 @@
+// Copyright 2021 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package platform
+
+import (
+	"fmt"
+	"os"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+// testConstructor is a Constructor of no platform.
+type testConstructor struct{}
+
+// New implements Constructor.New.
+func (testConstructor) New(*os.File) (Platform, error) {
+	return nil, fmt.Errorf("not implemented")
+}
+
+// OpenDevice implements Constructor.OpenDevice.
+func (testConstructor) OpenDevice() (*os.File, error) {
+	return nil, nil
+}
+
+// Requirements implements Constructor.Requirements.
+func (testConstructor) Requirements() Requirements {
+	return Requirements{}
+}
+
+// registerPanics returns true if Register(name, ...) panics.
+func registerPanics(name string) (panicked bool) {
+	defer func() {
+		if recover() != nil {
+			panicked = true
+		}
+	}()
+	Register(name, testConstructor{})
+	return false
+}
+
+func TestRegisterEmptyName(t *testing.T) {
+	if !registerPanics("") {
+		t.Errorf("Register with an empty name didn't panic")
+	}
+}
+
+func TestRegisterTwice(t *testing.T) {
+	if registerPanics("test-twice") {
+		t.Fatalf("Register of a new name panicked")
+	}
+	if !registerPanics("test-twice") {
+		t.Errorf("Register of an existing name didn't panic")
+	}
+	if _, err := Lookup("test-twice"); err != nil {
+		t.Errorf("Lookup failed: %v", err)
+	}
+}
+
+func TestList(t *testing.T) {
+	// Register out of order.
+	for _, name := range []string{"test-list-c", "test-list-a", "test-list-b"} {
+		Register(name, testConstructor{})
+	}
+	names := List()
+	if !sort.StringsAreSorted(names) {
+		t.Errorf("List got %v, want sorted names", names)
+	}
+	var got []string
+	for _, name := range names {
+		if name == "test-list-a" || name == "test-list-b" || name == "test-list-c" {
+			got = append(got, name)
+		}
+	}
+	if want := []string{"test-list-a", "test-list-b", "test-list-c"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("List got %v, want %v among its names", names, want)
+	}
+
+	if _, err := Lookup("test-list-unknown"); err == nil {
+		t.Errorf("Lookup of an unregistered platform succeeded")
+	}
+}
@@ -265,8 +265,9 @@ func (*constructor) Requirements() platform.Requirements {
 	// TODO(b/75837838): Also set a new PID namespace so that we limit
 	// access to other host processes.
 	return platform.Requirements{
-		RequiresCapSysPtrace: true,
-		RequiresCurrentPIDNS: true,
+		RequiresCapSysPtrace:       true,
+		RequiresCurrentPIDNS:       true,
+		RequiresReadableExecutable: true,
 	}
 }
 
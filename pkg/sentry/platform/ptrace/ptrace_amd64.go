@@ -16,12 +16,13 @@ package ptrace
 
 import (
 	"gvisor.dev/gvisor/pkg/abi/linux"
+	"gvisor.dev/gvisor/pkg/cpuid"
 	"gvisor.dev/gvisor/pkg/sentry/arch"
 )
 
 // fpRegSet returns the GETREGSET/SETREGSET register set type to be used.
-func fpRegSet(useXsave bool) uintptr {
-	if useXsave {
+func fpRegSet(fs *cpuid.FeatureSet) uintptr {
+	if fs.UseXsave() {
 		return linux.NT_X86_XSTATE
 	}
 	return linux.NT_PRFPREG
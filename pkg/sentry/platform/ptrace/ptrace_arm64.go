@@ -16,11 +16,15 @@ package ptrace
 
 import (
 	"gvisor.dev/gvisor/pkg/abi/linux"
+	"gvisor.dev/gvisor/pkg/cpuid"
 	"gvisor.dev/gvisor/pkg/sentry/arch"
 )
 
 // fpRegSet returns the GETREGSET/SETREGSET register set type to be used.
-func fpRegSet(_ bool) uintptr {
+func fpRegSet(fs *cpuid.FeatureSet) uintptr {
+	if fs.UseSVE() {
+		return linux.NT_ARM_SVE
+	}
 	return linux.NT_PRFPREG
 }
 
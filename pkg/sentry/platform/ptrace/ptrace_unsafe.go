@@ -19,6 +19,7 @@ import (
 	"unsafe"
 
 	"gvisor.dev/gvisor/pkg/abi/linux"
+	"gvisor.dev/gvisor/pkg/cpuid"
 	"gvisor.dev/gvisor/pkg/sentry/arch"
 	"gvisor.dev/gvisor/pkg/usermem"
 )
@@ -62,7 +63,7 @@ func (t *thread) setRegs(regs *arch.Registers) error {
 }
 
 // getFPRegs gets the floating-point data via the GETREGSET ptrace syscall.
-func (t *thread) getFPRegs(fpState *arch.FloatingPointData, fpLen uint64, useXsave bool) error {
+func (t *thread) getFPRegs(fpState *arch.FloatingPointData, fpLen uint64, fs *cpuid.FeatureSet) error {
 	iovec := syscall.Iovec{
 		Base: (*byte)(fpState),
 		Len:  fpLen,
@@ -71,7 +72,7 @@ func (t *thread) getFPRegs(fpState *arch.FloatingPointData, fpLen uint64, useXsa
 		syscall.SYS_PTRACE,
 		syscall.PTRACE_GETREGSET,
 		uintptr(t.tid),
-		fpRegSet(useXsave),
+		fpRegSet(fs),
 		uintptr(unsafe.Pointer(&iovec)),
 		0, 0)
 	if errno != 0 {
@@ -81,7 +82,7 @@ func (t *thread) getFPRegs(fpState *arch.FloatingPointData, fpLen uint64, useXsa
 }
 
 // setFPRegs sets the floating-point data via the SETREGSET ptrace syscall.
-func (t *thread) setFPRegs(fpState *arch.FloatingPointData, fpLen uint64, useXsave bool) error {
+func (t *thread) setFPRegs(fpState *arch.FloatingPointData, fpLen uint64, fs *cpuid.FeatureSet) error {
 	iovec := syscall.Iovec{
 		Base: (*byte)(fpState),
 		Len:  fpLen,
@@ -90,7 +91,7 @@ func (t *thread) setFPRegs(fpState *arch.FloatingPointData, fpLen uint64, useXsa
 		syscall.SYS_PTRACE,
 		syscall.PTRACE_SETREGSET,
 		uintptr(t.tid),
-		fpRegSet(useXsave),
+		fpRegSet(fs),
 		uintptr(unsafe.Pointer(&iovec)),
 		0, 0)
 	if errno != 0 {
@@ -309,6 +309,31 @@ func PackTimestamp(t *kernel.Task, timestamp int64, buf []byte) []byte {
 	)
 }
 
+// PackTimestampNS packs a SO_TIMESTAMPNS socket control message.
+func PackTimestampNS(t *kernel.Task, timestamp int64, buf []byte) []byte {
+	return putCmsgStruct(
+		buf,
+		linux.SOL_SOCKET,
+		linux.SCM_TIMESTAMPNS,
+		t.Arch().Width(),
+		linux.NsecToTimespec(timestamp),
+	)
+}
+
+// PackTimestamping packs a SO_TIMESTAMPING socket control message carrying a
+// software timestamp.
+func PackTimestamping(t *kernel.Task, timestamp int64, buf []byte) []byte {
+	var ts linux.ControlMessageTimestamping
+	ts.Ts[0] = linux.NsecToTimespec(timestamp)
+	return putCmsgStruct(
+		buf,
+		linux.SOL_SOCKET,
+		linux.SCM_TIMESTAMPING,
+		t.Arch().Width(),
+		&ts,
+	)
+}
+
 // PackInq packs a TCP_INQ socket control message.
 func PackInq(t *kernel.Task, inq int32, buf []byte) []byte {
 	return putCmsgStruct(
@@ -353,6 +378,53 @@ func PackIPPacketInfo(t *kernel.Task, packetInfo *linux.ControlMessageIPPacketIn
 	)
 }
 
+// PackIPv6PacketInfo packs an IPV6_PKTINFO socket control message.
+func PackIPv6PacketInfo(t *kernel.Task, packetInfo *linux.ControlMessageIPv6PacketInfo, buf []byte) []byte {
+	return putCmsgStruct(
+		buf,
+		linux.SOL_IPV6,
+		linux.IPV6_PKTINFO,
+		t.Arch().Width(),
+		packetInfo,
+	)
+}
+
+// PackTTL packs an IP_TTL socket control message.
+func PackTTL(t *kernel.Task, ttl uint8, buf []byte) []byte {
+	return putCmsgStruct(
+		buf,
+		linux.SOL_IP,
+		linux.IP_TTL,
+		t.Arch().Width(),
+		int32(ttl),
+	)
+}
+
+// PackHopLimit packs an IPV6_HOPLIMIT socket control message.
+func PackHopLimit(t *kernel.Task, hopLimit uint8, buf []byte) []byte {
+	return putCmsgStruct(
+		buf,
+		linux.SOL_IPV6,
+		linux.IPV6_HOPLIMIT,
+		t.Arch().Width(),
+		int32(hopLimit),
+	)
+}
+
+// PackFlowInfo packs an IPV6_FLOWINFO socket control message. Like in Linux,
+// the flow information is in network byte order.
+func PackFlowInfo(t *kernel.Task, flowInfo uint32, buf []byte) []byte {
+	var v [linux.SizeOfControlMessageFlowInfo]byte
+	binary.BigEndian.PutUint32(v[:], flowInfo)
+	return putCmsgStruct(
+		buf,
+		linux.SOL_IPV6,
+		linux.IPV6_FLOWINFO,
+		t.Arch().Width(),
+		v,
+	)
+}
+
 // PackOriginalDstAddress packs an IP_RECVORIGINALDSTADDR socket control message.
 func PackOriginalDstAddress(t *kernel.Task, originalDstAddress linux.SockAddr, buf []byte) []byte {
 	var level uint32
@@ -382,6 +454,28 @@ func PackSockExtendedErr(t *kernel.Task, sockErr linux.SockErrCMsg, buf []byte)
 	)
 }
 
+// PackGROSize packs a UDP_GRO socket control message.
+func PackGROSize(t *kernel.Task, groSize uint16, buf []byte) []byte {
+	return putCmsgStruct(
+		buf,
+		linux.SOL_UDP,
+		linux.UDP_GRO,
+		t.Arch().Width(),
+		int32(groSize),
+	)
+}
+
+// PackTLSRecordType packs a TLS_GET_RECORD_TYPE socket control message.
+func PackTLSRecordType(t *kernel.Task, recordType uint8, buf []byte) []byte {
+	return putCmsgStruct(
+		buf,
+		linux.SOL_TLS,
+		linux.TLS_GET_RECORD_TYPE,
+		t.Arch().Width(),
+		recordType,
+	)
+}
+
 // PackControlMessages packs control messages into the given buffer.
 //
 // We skip control messages specific to Unix domain sockets.
@@ -393,6 +487,15 @@ func PackControlMessages(t *kernel.Task, cmsgs socket.ControlMessages, buf []byt
 		buf = PackTimestamp(t, cmsgs.IP.Timestamp, buf)
 	}
 
+	if cmsgs.IP.HasTimestampNS {
+		buf = PackTimestampNS(t, cmsgs.IP.Timestamp, buf)
+	}
+
+	if cmsgs.IP.HasTimestamping {
+		// In Linux, SCM_TIMESTAMPING is added after SO_TIMESTAMP{,NS}.
+		buf = PackTimestamping(t, cmsgs.IP.Timestamp, buf)
+	}
+
 	if cmsgs.IP.HasInq {
 		// In Linux, TCP_CM_INQ is added after SO_TIMESTAMP.
 		buf = PackInq(t, cmsgs.IP.Inq, buf)
@@ -410,6 +513,22 @@ func PackControlMessages(t *kernel.Task, cmsgs socket.ControlMessages, buf []byt
 		buf = PackIPPacketInfo(t, &cmsgs.IP.PacketInfo, buf)
 	}
 
+	if cmsgs.IP.HasTTL {
+		buf = PackTTL(t, cmsgs.IP.TTL, buf)
+	}
+
+	if cmsgs.IP.HasIPv6PacketInfo {
+		buf = PackIPv6PacketInfo(t, &cmsgs.IP.IPv6PacketInfo, buf)
+	}
+
+	if cmsgs.IP.HasHopLimit {
+		buf = PackHopLimit(t, cmsgs.IP.HopLimit, buf)
+	}
+
+	if cmsgs.IP.HasFlowInfo {
+		buf = PackFlowInfo(t, cmsgs.IP.FlowInfo, buf)
+	}
+
 	if cmsgs.IP.OriginalDstAddress != nil {
 		buf = PackOriginalDstAddress(t, cmsgs.IP.OriginalDstAddress, buf)
 	}
@@ -418,6 +537,14 @@ func PackControlMessages(t *kernel.Task, cmsgs socket.ControlMessages, buf []byt
 		buf = PackSockExtendedErr(t, cmsgs.IP.SockErr, buf)
 	}
 
+	if cmsgs.IP.HasGROSize {
+		buf = PackGROSize(t, cmsgs.IP.GROSize, buf)
+	}
+
+	if cmsgs.IP.HasTLSRecordType {
+		buf = PackTLSRecordType(t, cmsgs.IP.TLSRecordType, buf)
+	}
+
 	return buf
 }
 
@@ -435,6 +562,14 @@ func CmsgsSpace(t *kernel.Task, cmsgs socket.ControlMessages) int {
 		space += cmsgSpace(t, linux.SizeOfTimeval)
 	}
 
+	if cmsgs.IP.HasTimestampNS {
+		space += cmsgSpace(t, linux.SizeOfControlMessageTimespec)
+	}
+
+	if cmsgs.IP.HasTimestamping {
+		space += cmsgSpace(t, linux.SizeOfControlMessageTimestamping)
+	}
+
 	if cmsgs.IP.HasInq {
 		space += cmsgSpace(t, linux.SizeOfControlMessageInq)
 	}
@@ -451,6 +586,22 @@ func CmsgsSpace(t *kernel.Task, cmsgs socket.ControlMessages) int {
 		space += cmsgSpace(t, linux.SizeOfControlMessageIPPacketInfo)
 	}
 
+	if cmsgs.IP.HasTTL {
+		space += cmsgSpace(t, linux.SizeOfControlMessageTTL)
+	}
+
+	if cmsgs.IP.HasIPv6PacketInfo {
+		space += cmsgSpace(t, linux.SizeOfControlMessageIPv6PacketInfo)
+	}
+
+	if cmsgs.IP.HasHopLimit {
+		space += cmsgSpace(t, linux.SizeOfControlMessageHopLimit)
+	}
+
+	if cmsgs.IP.HasFlowInfo {
+		space += cmsgSpace(t, linux.SizeOfControlMessageFlowInfo)
+	}
+
 	if cmsgs.IP.OriginalDstAddress != nil {
 		space += cmsgSpace(t, cmsgs.IP.OriginalDstAddress.SizeBytes())
 	}
@@ -459,6 +610,14 @@ func CmsgsSpace(t *kernel.Task, cmsgs socket.ControlMessages) int {
 		space += cmsgSpace(t, cmsgs.IP.SockErr.SizeBytes())
 	}
 
+	if cmsgs.IP.HasGROSize {
+		space += cmsgSpace(t, linux.SizeOfControlMessageUDPGRO)
+	}
+
+	if cmsgs.IP.HasTLSRecordType {
+		space += cmsgSpace(t, linux.SizeOfControlMessageTLSRecordType)
+	}
+
 	return space
 }
 
@@ -586,6 +745,18 @@ func Parse(t *kernel.Task, socketOrEndpoint interface{}, buf []byte, width uint)
 				binary.Unmarshal(buf[i:i+linux.SizeOfControlMessageTClass], usermem.ByteOrder, &cmsgs.IP.TClass)
 				i += binary.AlignUp(length, width)
 
+			case linux.IPV6_PKTINFO:
+				if length < linux.SizeOfControlMessageIPv6PacketInfo {
+					return socket.ControlMessages{}, syserror.EINVAL
+				}
+
+				cmsgs.IP.HasIPv6PacketInfo = true
+				var packetInfo linux.ControlMessageIPv6PacketInfo
+				binary.Unmarshal(buf[i:i+linux.SizeOfControlMessageIPv6PacketInfo], usermem.ByteOrder, &packetInfo)
+
+				cmsgs.IP.IPv6PacketInfo = packetInfo
+				i += binary.AlignUp(length, width)
+
 			case linux.IPV6_RECVORIGDSTADDR:
 				var addr linux.SockAddrInet6
 				if length < addr.SizeBytes() {
@@ -605,6 +776,32 @@ func Parse(t *kernel.Task, socketOrEndpoint interface{}, buf []byte, width uint)
 				cmsgs.IP.SockErr = &errCmsg
 				i += binary.AlignUp(length, width)
 
+			default:
+				return socket.ControlMessages{}, syserror.EINVAL
+			}
+		case linux.SOL_UDP:
+			switch h.Type {
+			case linux.UDP_SEGMENT:
+				if length < linux.SizeOfControlMessageUDPSegment {
+					return socket.ControlMessages{}, syserror.EINVAL
+				}
+				cmsgs.IP.HasGSOSize = true
+				binary.Unmarshal(buf[i:i+linux.SizeOfControlMessageUDPSegment], usermem.ByteOrder, &cmsgs.IP.GSOSize)
+				i += binary.AlignUp(length, width)
+
+			default:
+				return socket.ControlMessages{}, syserror.EINVAL
+			}
+		case linux.SOL_TLS:
+			switch h.Type {
+			case linux.TLS_SET_RECORD_TYPE:
+				if length != linux.SizeOfControlMessageTLSRecordType {
+					return socket.ControlMessages{}, syserror.EINVAL
+				}
+				cmsgs.IP.HasTLSRecordType = true
+				cmsgs.IP.TLSRecordType = buf[i]
+				i += binary.AlignUp(length, width)
+
 			default:
 				return socket.ControlMessages{}, syserror.EINVAL
 			}
@@ -0,0 +1,96 @@
+// Copyright 2021 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package hostinet
+
+import (
+	"fmt"
+	"syscall"
+
+	"gvisor.dev/gvisor/pkg/abi/linux"
+	"gvisor.dev/gvisor/pkg/context"
+	"gvisor.dev/gvisor/pkg/sentry/fs"
+	"gvisor.dev/gvisor/pkg/sentry/kernel"
+	"gvisor.dev/gvisor/pkg/sentry/vfs"
+)
+
+// CanImport returns true if fd is a host TCP or UDP socket, which can be
+// imported with Import or ImportVFS2.
+func CanImport(fd int) bool {
+	_, _, _, err := importedSocketType(fd)
+	return err == nil
+}
+
+// importedSocketType returns the family, type and protocol of the host socket
+// fd, which must be a TCP or UDP socket. Only the socket options allowed by the
+// sandbox's seccomp filters are queried.
+func importedSocketType(fd int) (int, linux.SockType, int, error) {
+	family, err := syscall.GetsockoptInt(fd, syscall.SOL_SOCKET, syscall.SO_DOMAIN)
+	if err != nil {
+		return 0, 0, 0, err
+	}
+	if family != syscall.AF_INET && family != syscall.AF_INET6 {
+		return 0, 0, 0, fmt.Errorf("socket family %d is not AF_INET or AF_INET6", family)
+	}
+	stype, err := syscall.GetsockoptInt(fd, syscall.SOL_SOCKET, syscall.SO_TYPE)
+	if err != nil {
+		return 0, 0, 0, err
+	}
+	switch stype {
+	case syscall.SOCK_STREAM:
+		return family, linux.SOCK_STREAM, syscall.IPPROTO_TCP, nil
+	case syscall.SOCK_DGRAM:
+		return family, linux.SOCK_DGRAM, syscall.IPPROTO_UDP, nil
+	default:
+		return 0, 0, 0, fmt.Errorf("socket type %d is not SOCK_STREAM or SOCK_DGRAM", stype)
+	}
+}
+
+// Import returns a socket file for the host TCP or UDP socket fd, such as one
+// passed to the sandbox by socket activation. The socket must only be used
+// with the host network stack. Import takes ownership of fd if it succeeds.
+func Import(ctx context.Context, fd int) (*fs.File, error) {
+	family, stype, protocol, err := importedSocketType(fd)
+	if err != nil {
+		return nil, err
+	}
+	// socketOperations requires non-blocking host sockets.
+	if err := syscall.SetNonblock(fd, true); err != nil {
+		return nil, err
+	}
+	f, serr := newSocketFile(ctx, family, stype, protocol, fd, false /* nonblock */)
+	if serr != nil {
+		return nil, serr.ToError()
+	}
+	kernel.KernelFromContext(ctx).RecordSocket(f)
+	return f, nil
+}
+
+// ImportVFS2 is the VFS2 version of Import.
+func ImportVFS2(ctx context.Context, fd int) (*vfs.FileDescription, error) {
+	family, stype, protocol, err := importedSocketType(fd)
+	if err != nil {
+		return nil, err
+	}
+	// socketOpsCommon requires non-blocking host sockets.
+	if err := syscall.SetNonblock(fd, true); err != nil {
+		return nil, err
+	}
+	f, serr := newVFS2Socket(ctx, family, stype, protocol, fd, 0 /* flags */)
+	if serr != nil {
+		return nil, serr.ToError()
+	}
+	kernel.KernelFromContext(ctx).RecordSocketVFS2(f)
+	return f, nil
+}
@@ -331,12 +331,12 @@ func (s *socketOpsCommon) GetSockOpt(t *kernel.Task, level int, name int, outPtr
 	switch level {
 	case linux.SOL_IP:
 		switch name {
-		case linux.IP_TOS, linux.IP_RECVTOS, linux.IP_PKTINFO, linux.IP_RECVORIGDSTADDR, linux.IP_RECVERR:
+		case linux.IP_TOS, linux.IP_RECVTOS, linux.IP_PKTINFO, linux.IP_RECVORIGDSTADDR, linux.IP_RECVERR, linux.IP_TTL, linux.IP_RECVTTL:
 			optlen = sizeofInt32
 		}
 	case linux.SOL_IPV6:
 		switch name {
-		case linux.IPV6_TCLASS, linux.IPV6_RECVTCLASS, linux.IPV6_RECVERR, linux.IPV6_V6ONLY, linux.IPV6_RECVORIGDSTADDR:
+		case linux.IPV6_TCLASS, linux.IPV6_RECVTCLASS, linux.IPV6_RECVERR, linux.IPV6_V6ONLY, linux.IPV6_RECVORIGDSTADDR, linux.IPV6_RECVPKTINFO, linux.IPV6_RECVHOPLIMIT, linux.IPV6_UNICAST_HOPS:
 			optlen = sizeofInt32
 		}
 	case linux.SOL_SOCKET:
@@ -377,12 +377,12 @@ func (s *socketOpsCommon) SetSockOpt(t *kernel.Task, level int, name int, opt []
 	switch level {
 	case linux.SOL_IP:
 		switch name {
-		case linux.IP_TOS, linux.IP_RECVTOS, linux.IP_PKTINFO, linux.IP_RECVORIGDSTADDR, linux.IP_RECVERR:
+		case linux.IP_TOS, linux.IP_RECVTOS, linux.IP_PKTINFO, linux.IP_RECVORIGDSTADDR, linux.IP_RECVERR, linux.IP_TTL, linux.IP_RECVTTL:
 			optlen = sizeofInt32
 		}
 	case linux.SOL_IPV6:
 		switch name {
-		case linux.IPV6_TCLASS, linux.IPV6_RECVTCLASS, linux.IPV6_RECVERR, linux.IPV6_V6ONLY, linux.IPV6_RECVORIGDSTADDR:
+		case linux.IPV6_TCLASS, linux.IPV6_RECVTCLASS, linux.IPV6_RECVERR, linux.IPV6_V6ONLY, linux.IPV6_RECVORIGDSTADDR, linux.IPV6_RECVPKTINFO, linux.IPV6_RECVHOPLIMIT, linux.IPV6_UNICAST_HOPS:
 			optlen = sizeofInt32
 		}
 	case linux.SOL_SOCKET:
@@ -448,14 +448,19 @@ func (s *socketOpsCommon) recvMsgFromHost(iovs []syscall.Iovec, flags int, sende
 // RecvMsg implements socket.Socket.RecvMsg.
 func (s *socketOpsCommon) RecvMsg(t *kernel.Task, dst usermem.IOSequence, flags int, haveDeadline bool, deadline ktime.Time, senderRequested bool, controlLen uint64) (int, int, linux.SockAddr, uint32, socket.ControlMessages, *syserr.Error) {
 	// Only allow known and safe flags.
-	if flags&^(syscall.MSG_DONTWAIT|syscall.MSG_PEEK|syscall.MSG_TRUNC|syscall.MSG_ERRQUEUE) != 0 {
+	if flags&^(syscall.MSG_DONTWAIT|syscall.MSG_PEEK|syscall.MSG_TRUNC|syscall.MSG_ERRQUEUE|syscall.MSG_WAITALL|syscall.MSG_CMSG_CLOEXEC) != 0 {
 		return 0, 0, nil, 0, socket.ControlMessages{}, syserr.ErrInvalidArgument
 	}
+	// MSG_CMSG_CLOEXEC only applies to file descriptors received with
+	// SCM_RIGHTS, which host sockets never pass. MSG_WAITALL is handled
+	// below, as the host receives are non-blocking.
+	waitAll := flags&syscall.MSG_WAITALL != 0 && s.stype == linux.SOCK_STREAM
+	flags &^= syscall.MSG_CMSG_CLOEXEC | syscall.MSG_WAITALL
 
 	var senderAddrBuf []byte
 	var controlBuf []byte
 	var msgFlags int
-	copyToDst := func() (int64, error) {
+	copyToDst := func(dst usermem.IOSequence) (int64, error) {
 		var n uint64
 		var err error
 		if dst.NumBytes() == 0 {
@@ -481,7 +486,7 @@ func (s *socketOpsCommon) RecvMsg(t *kernel.Task, dst usermem.IOSequence, flags
 	}
 
 	var ch chan struct{}
-	n, err := copyToDst()
+	n, err := copyToDst(dst)
 	// recv*(MSG_ERRQUEUE) never blocks, even without MSG_DONTWAIT.
 	if flags&(syscall.MSG_DONTWAIT|syscall.MSG_ERRQUEUE) == 0 {
 		for err == syserror.ErrWouldBlock {
@@ -500,13 +505,39 @@ func (s *socketOpsCommon) RecvMsg(t *kernel.Task, dst usermem.IOSequence, flags
 				s.EventRegister(&e, waiter.EventIn)
 				defer s.EventUnregister(&e)
 			}
-			n, err = copyToDst()
+			n, err = copyToDst(dst)
 		}
 	}
 	if err != nil {
 		return 0, 0, nil, 0, socket.ControlMessages{}, syserr.FromError(err)
 	}
 
+	// With MSG_WAITALL, keep receiving until dst is full, the peer shuts
+	// down the connection or an error occurs, returning the data received
+	// so far in the latter cases.
+	if waitAll && n > 0 && flags&(syscall.MSG_DONTWAIT|syscall.MSG_PEEK|syscall.MSG_ERRQUEUE) == 0 {
+		for n < dst.NumBytes() {
+			rn, rerr := copyToDst(dst.DropFirst64(n))
+			if rerr == syserror.ErrWouldBlock {
+				if ch == nil {
+					var e waiter.Entry
+					e, ch = waiter.NewChannelEntry(nil)
+					s.EventRegister(&e, waiter.EventIn)
+					defer s.EventUnregister(&e)
+					continue
+				}
+				if t.BlockWithDeadline(ch, haveDeadline, deadline) != nil {
+					break
+				}
+				continue
+			}
+			if rerr != nil || rn == 0 {
+				break
+			}
+			n += rn
+		}
+	}
+
 	var senderAddr linux.SockAddr
 	if senderRequested {
 		senderAddr = socket.UnmarshalSockAddr(s.family, senderAddrBuf)
@@ -542,6 +573,12 @@ func parseUnixControlMessages(unixControlMessages []unix.SocketControlMessage) s
 				binary.Unmarshal(unixCmsg.Data[:linux.SizeOfControlMessageIPPacketInfo], usermem.ByteOrder, &packetInfo)
 				controlMessages.IP.PacketInfo = packetInfo
 
+			case linux.IP_TTL:
+				var ttl int32
+				binary.Unmarshal(unixCmsg.Data[:linux.SizeOfControlMessageTTL], usermem.ByteOrder, &ttl)
+				controlMessages.IP.HasTTL = true
+				controlMessages.IP.TTL = uint8(ttl)
+
 			case linux.IP_RECVORIGDSTADDR:
 				var addr linux.SockAddrInet
 				binary.Unmarshal(unixCmsg.Data[:addr.SizeBytes()], usermem.ByteOrder, &addr)
@@ -559,6 +596,18 @@ func parseUnixControlMessages(unixControlMessages []unix.SocketControlMessage) s
 				controlMessages.IP.HasTClass = true
 				binary.Unmarshal(unixCmsg.Data[:linux.SizeOfControlMessageTClass], usermem.ByteOrder, &controlMessages.IP.TClass)
 
+			case linux.IPV6_PKTINFO:
+				controlMessages.IP.HasIPv6PacketInfo = true
+				var packetInfo linux.ControlMessageIPv6PacketInfo
+				binary.Unmarshal(unixCmsg.Data[:linux.SizeOfControlMessageIPv6PacketInfo], usermem.ByteOrder, &packetInfo)
+				controlMessages.IP.IPv6PacketInfo = packetInfo
+
+			case linux.IPV6_HOPLIMIT:
+				var hopLimit int32
+				binary.Unmarshal(unixCmsg.Data[:linux.SizeOfControlMessageHopLimit], usermem.ByteOrder, &hopLimit)
+				controlMessages.IP.HasHopLimit = true
+				controlMessages.IP.HopLimit = uint8(hopLimit)
+
 			case linux.IPV6_RECVORIGDSTADDR:
 				var addr linux.SockAddrInet6
 				binary.Unmarshal(unixCmsg.Data[:addr.SizeBytes()], usermem.ByteOrder, &addr)
@@ -588,6 +637,13 @@ func (s *socketOpsCommon) SendMsg(t *kernel.Task, src usermem.IOSequence, to []b
 		return 0, syserr.ErrInvalidArgument
 	}
 
+	// Reject Unix control messages, like netstack: SCM_RIGHTS and
+	// SCM_CREDENTIALS are only passed by Unix domain sockets, which are
+	// implemented by the sentry rather than by host sockets.
+	if !controlMessages.Unix.Empty() {
+		return 0, syserr.ErrInvalidArgument
+	}
+
 	space := uint64(control.CmsgsSpace(t, controlMessages))
 	if space > maxControlLen {
 		space = maxControlLen
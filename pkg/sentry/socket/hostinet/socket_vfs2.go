@@ -49,10 +49,10 @@ type socketVFS2 struct {
 
 var _ = socket.SocketVFS2(&socketVFS2{})
 
-func newVFS2Socket(t *kernel.Task, family int, stype linux.SockType, protocol int, fd int, flags uint32) (*vfs.FileDescription, *syserr.Error) {
-	mnt := t.Kernel().SocketMount()
-	d := sockfs.NewDentry(t, mnt)
-	defer d.DecRef(t)
+func newVFS2Socket(ctx context.Context, family int, stype linux.SockType, protocol int, fd int, flags uint32) (*vfs.FileDescription, *syserr.Error) {
+	mnt := kernel.KernelFromContext(ctx).SocketMount()
+	d := sockfs.NewDentry(ctx, mnt)
+	defer d.DecRef(ctx)
 
 	s := &socketVFS2{
 		socketOpsCommon: socketOpsCommon{
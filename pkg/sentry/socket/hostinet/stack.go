@@ -52,18 +52,23 @@ var defaultSendBufSize = inet.TCPBufferSize{
 // Stack implements inet.Stack for host sockets.
 type Stack struct {
 	// Stack is immutable.
-	interfaces     map[int32]inet.Interface
-	interfaceAddrs map[int32][]inet.InterfaceAddr
-	routes         []inet.Route
-	supportsIPv6   bool
-	tcpRecovery    inet.TCPLossRecovery
-	tcpRecvBufSize inet.TCPBufferSize
-	tcpSendBufSize inet.TCPBufferSize
-	tcpSACKEnabled bool
-	netDevFile     *os.File
-	netSNMPFile    *os.File
-	ipv4Forwarding bool
-	ipv6Forwarding bool
+	interfaces         map[int32]inet.Interface
+	interfaceAddrs     map[int32][]inet.InterfaceAddr
+	routes             []inet.Route
+	supportsIPv6       bool
+	tcpRecovery        inet.TCPLossRecovery
+	tcpRecvBufSize     inet.TCPBufferSize
+	tcpSendBufSize     inet.TCPBufferSize
+	tcpSACKEnabled     bool
+	tcpECN             inet.TCPECNMode
+	tcpECNFallback     bool
+	tcpSynCookies      inet.TCPSynCookiesMode
+	tcpAbortOnOverflow bool
+	tcpMigrateReq      bool
+	netDevFile         *os.File
+	netSNMPFile        *os.File
+	ipv4Forwarding     bool
+	ipv6Forwarding     bool
 }
 
 // NewStack returns an empty Stack containing no configuration.
@@ -111,6 +116,44 @@ func (s *Stack) Configure() error {
 		log.Warningf("Failed to read if TCP SACK if enabled, setting to true")
 	}
 
+	// Linux defaults to accepting ECN without requesting it.
+	s.tcpECN = 2
+	if ecn, err := ioutil.ReadFile("/proc/sys/net/ipv4/tcp_ecn"); err == nil {
+		if v, err := strconv.ParseInt(strings.TrimSpace(string(ecn)), 10, 32); err == nil {
+			s.tcpECN = inet.TCPECNMode(v)
+		}
+	} else {
+		log.Warningf("Failed to read TCP ECN mode, setting to 2")
+	}
+
+	s.tcpECNFallback = true
+	if fallback, err := ioutil.ReadFile("/proc/sys/net/ipv4/tcp_ecn_fallback"); err == nil {
+		s.tcpECNFallback = strings.TrimSpace(string(fallback)) != "0"
+	} else {
+		log.Warningf("Failed to read if TCP ECN fallback is enabled, setting to true")
+	}
+
+	// Linux defaults to sending SYN cookies when the SYN queue overflows.
+	s.tcpSynCookies = 1
+	if cookies, err := ioutil.ReadFile("/proc/sys/net/ipv4/tcp_syncookies"); err == nil {
+		if v, err := strconv.ParseInt(strings.TrimSpace(string(cookies)), 10, 32); err == nil {
+			s.tcpSynCookies = inet.TCPSynCookiesMode(v)
+		}
+	} else {
+		log.Warningf("Failed to read TCP SYN cookies mode, setting to 1")
+	}
+
+	if abort, err := ioutil.ReadFile("/proc/sys/net/ipv4/tcp_abort_on_overflow"); err == nil {
+		s.tcpAbortOnOverflow = strings.TrimSpace(string(abort)) != "0"
+	} else {
+		log.Warningf("Failed to read if TCP abort on overflow is enabled, setting to false")
+	}
+
+	// tcp_migrate_req is only present since Linux 5.14.
+	if migrate, err := ioutil.ReadFile("/proc/sys/net/ipv4/tcp_migrate_req"); err == nil {
+		s.tcpMigrateReq = strings.TrimSpace(string(migrate)) != "0"
+	}
+
 	if f, err := os.Open("/proc/net/dev"); err != nil {
 		log.Warningf("Failed to open /proc/net/dev: %v", err)
 	} else {
@@ -214,7 +257,7 @@ func ExtractHostRoutes(routeMsgs []syscall.NetlinkMessage) ([]inet.Route, error)
 			DstLen:   ifRoute.Dst_len,
 			SrcLen:   ifRoute.Src_len,
 			TOS:      ifRoute.Tos,
-			Table:    ifRoute.Table,
+			Table:    uint32(ifRoute.Table),
 			Protocol: ifRoute.Protocol,
 			Scope:    ifRoute.Scope,
 			Type:     ifRoute.Type,
@@ -323,6 +366,16 @@ func (s *Stack) InterfaceAddrs() map[int32][]inet.InterfaceAddr {
 	return addrs
 }
 
+// AddInterface implements inet.Stack.AddInterface.
+func (s *Stack) AddInterface(inet.Interface) (int32, error) {
+	return 0, syserror.EACCES
+}
+
+// RemoveInterface implements inet.Stack.RemoveInterface.
+func (s *Stack) RemoveInterface(int32) error {
+	return syserror.EACCES
+}
+
 // AddInterfaceAddr implements inet.Stack.AddInterfaceAddr.
 func (s *Stack) AddInterfaceAddr(int32, inet.InterfaceAddr) error {
 	return syserror.EACCES
@@ -333,6 +386,31 @@ func (s *Stack) RemoveInterfaceAddr(int32, inet.InterfaceAddr) error {
 	return syserror.EACCES
 }
 
+// SetInterfaceMTU implements inet.Stack.SetInterfaceMTU.
+func (s *Stack) SetInterfaceMTU(int32, uint32) error {
+	return syserror.EACCES
+}
+
+// SetInterfaceUp implements inet.Stack.SetInterfaceUp.
+func (s *Stack) SetInterfaceUp(int32, bool) error {
+	return syserror.EACCES
+}
+
+// Neighbors implements inet.Stack.Neighbors.
+func (s *Stack) Neighbors() map[int32][]inet.Neighbor {
+	return nil
+}
+
+// AddNeighbor implements inet.Stack.AddNeighbor.
+func (s *Stack) AddNeighbor(int32, inet.Neighbor) error {
+	return syserror.EACCES
+}
+
+// RemoveNeighbor implements inet.Stack.RemoveNeighbor.
+func (s *Stack) RemoveNeighbor(int32, inet.Neighbor) error {
+	return syserror.EACCES
+}
+
 // SupportsIPv6 implements inet.Stack.SupportsIPv6.
 func (s *Stack) SupportsIPv6() bool {
 	return s.supportsIPv6
@@ -378,6 +456,56 @@ func (s *Stack) SetTCPRecovery(inet.TCPLossRecovery) error {
 	return syserror.EACCES
 }
 
+// TCPECN implements inet.Stack.TCPECN.
+func (s *Stack) TCPECN() (inet.TCPECNMode, error) {
+	return s.tcpECN, nil
+}
+
+// SetTCPECN implements inet.Stack.SetTCPECN.
+func (s *Stack) SetTCPECN(inet.TCPECNMode) error {
+	return syserror.EACCES
+}
+
+// TCPECNFallback implements inet.Stack.TCPECNFallback.
+func (s *Stack) TCPECNFallback() (bool, error) {
+	return s.tcpECNFallback, nil
+}
+
+// SetTCPECNFallback implements inet.Stack.SetTCPECNFallback.
+func (s *Stack) SetTCPECNFallback(bool) error {
+	return syserror.EACCES
+}
+
+// TCPSynCookies implements inet.Stack.TCPSynCookies.
+func (s *Stack) TCPSynCookies() (inet.TCPSynCookiesMode, error) {
+	return s.tcpSynCookies, nil
+}
+
+// SetTCPSynCookies implements inet.Stack.SetTCPSynCookies.
+func (s *Stack) SetTCPSynCookies(inet.TCPSynCookiesMode) error {
+	return syserror.EACCES
+}
+
+// TCPAbortOnOverflow implements inet.Stack.TCPAbortOnOverflow.
+func (s *Stack) TCPAbortOnOverflow() (bool, error) {
+	return s.tcpAbortOnOverflow, nil
+}
+
+// SetTCPAbortOnOverflow implements inet.Stack.SetTCPAbortOnOverflow.
+func (s *Stack) SetTCPAbortOnOverflow(bool) error {
+	return syserror.EACCES
+}
+
+// TCPMigrateReq implements inet.Stack.TCPMigrateReq.
+func (s *Stack) TCPMigrateReq() (bool, error) {
+	return s.tcpMigrateReq, nil
+}
+
+// SetTCPMigrateReq implements inet.Stack.SetTCPMigrateReq.
+func (s *Stack) SetTCPMigrateReq(bool) error {
+	return syserror.EACCES
+}
+
 // getLine reads one line from proc file, with specified prefix.
 // The last argument, withHeader, specifies if it contains line header.
 func getLine(f *os.File, prefix string, withHeader bool) string {
@@ -474,6 +602,91 @@ func (s *Stack) RouteTable() []inet.Route {
 	return append([]inet.Route(nil), s.routes...)
 }
 
+// AddRoute implements inet.Stack.AddRoute.
+func (s *Stack) AddRoute(inet.Route) error {
+	return syserror.EACCES
+}
+
+// RemoveRoute implements inet.Stack.RemoveRoute.
+func (s *Stack) RemoveRoute(inet.Route) error {
+	return syserror.EACCES
+}
+
+// RouteRules implements inet.Stack.RouteRules.
+func (s *Stack) RouteRules() []inet.Rule {
+	return nil
+}
+
+// AddRouteRule implements inet.Stack.AddRouteRule.
+func (s *Stack) AddRouteRule(inet.Rule) error {
+	return syserror.EACCES
+}
+
+// RemoveRouteRule implements inet.Stack.RemoveRouteRule.
+func (s *Stack) RemoveRouteRule(inet.Rule) error {
+	return syserror.EACCES
+}
+
+// QueueingDisciplines implements inet.Stack.QueueingDisciplines.
+func (s *Stack) QueueingDisciplines() map[int32]inet.QueueingDiscipline {
+	return nil
+}
+
+// SetQueueingDiscipline implements inet.Stack.SetQueueingDiscipline.
+func (s *Stack) SetQueueingDiscipline(int32, *inet.QueueingDiscipline) error {
+	return syserror.EACCES
+}
+
+// ConnTrackEntries implements inet.Stack.ConnTrackEntries.
+func (s *Stack) ConnTrackEntries() ([]inet.ConnTrackEntry, error) {
+	return nil, syserror.EACCES
+}
+
+// RemoveConnTrackEntries implements inet.Stack.RemoveConnTrackEntries.
+func (s *Stack) RemoveConnTrackEntries(func(*inet.ConnTrackEntry) bool) (int, error) {
+	return 0, syserror.EACCES
+}
+
+// ConnTrackSettings implements inet.Stack.ConnTrackSettings.
+func (s *Stack) ConnTrackSettings() (inet.ConnTrackSettings, error) {
+	return inet.ConnTrackSettings{}, syserror.EACCES
+}
+
+// SetConnTrackSettings implements inet.Stack.SetConnTrackSettings.
+func (s *Stack) SetConnTrackSettings(inet.ConnTrackSettings) error {
+	return syserror.EACCES
+}
+
+// ConnTrackStats implements inet.Stack.ConnTrackStats.
+func (s *Stack) ConnTrackStats() (inet.ConnTrackStats, error) {
+	return inet.ConnTrackStats{}, syserror.EACCES
+}
+
+// AddressLabels implements inet.Stack.AddressLabels.
+func (s *Stack) AddressLabels() ([]inet.AddressLabel, error) {
+	return nil, syserror.EACCES
+}
+
+// AddAddressLabel implements inet.Stack.AddAddressLabel.
+func (s *Stack) AddAddressLabel(inet.AddressLabel, bool) error {
+	return syserror.EACCES
+}
+
+// RemoveAddressLabel implements inet.Stack.RemoveAddressLabel.
+func (s *Stack) RemoveAddressLabel(inet.AddressLabel) error {
+	return syserror.EACCES
+}
+
+// IPv6TempAddrSettings implements inet.Stack.IPv6TempAddrSettings.
+func (s *Stack) IPv6TempAddrSettings() (inet.IPv6TempAddrSettings, error) {
+	return inet.IPv6TempAddrSettings{}, syserror.EACCES
+}
+
+// SetIPv6TempAddrSettings implements inet.Stack.SetIPv6TempAddrSettings.
+func (s *Stack) SetIPv6TempAddrSettings(inet.IPv6TempAddrSettings) error {
+	return syserror.EACCES
+}
+
 // Resume implements inet.Stack.Resume.
 func (s *Stack) Resume() {}
 
@@ -254,6 +254,16 @@ func SetEntries(stk *stack.Stack, optVal []byte, ipv6 bool) *syserr.Error {
 		}
 	}
 
+	// As in Linux, the TPROXY target is only valid in the mangle table.
+	if nameToID[replace.Name.String()] != stack.MangleID {
+		for _, rule := range table.Rules {
+			if _, ok := rule.Target.(*tproxyTarget); ok {
+				nflog("TPROXY target is only valid in the mangle table")
+				return syserr.ErrInvalidArgument
+			}
+		}
+	}
+
 	// Set each jump to point to the appropriate rule. Right now they hold byte
 	// offsets.
 	for ruleIdx, rule := range table.Rules {
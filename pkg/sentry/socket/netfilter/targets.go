@@ -35,6 +35,11 @@ const ErrorTargetName = "ERROR"
 // change the destination port and/or IP for packets.
 const RedirectTargetName = "REDIRECT"
 
+// TProxyTargetName is used to mark targets as TPROXY targets. TPROXY targets
+// should be reached for only the mangle table. These targets divert packets to
+// a transparent proxy without modifying them.
+const TProxyTargetName = "TPROXY"
+
 func init() {
 	// Standard targets include ACCEPT, DROP, RETURN, and JUMP.
 	registerTargetMaker(&standardTargetMaker{
@@ -59,6 +64,13 @@ func init() {
 	registerTargetMaker(&nfNATTargetMaker{
 		NetworkProtocol: header.IPv6ProtocolNumber,
 	})
+
+	registerTargetMaker(&tproxyTargetMaker{
+		NetworkProtocol: header.IPv4ProtocolNumber,
+	})
+	registerTargetMaker(&tproxyTargetMaker{
+		NetworkProtocol: header.IPv6ProtocolNumber,
+	})
 }
 
 // The stack package provides some basic, useful targets for us. The following
@@ -131,6 +143,23 @@ func (rt *redirectTarget) id() targetID {
 	}
 }
 
+type tproxyTarget struct {
+	stack.TProxyTarget
+
+	// markMask and markValue must be (un)marshalled when reading and writing
+	// the target to userspace, but do not affect behavior.
+	markMask  uint32
+	markValue uint32
+}
+
+func (tt *tproxyTarget) id() targetID {
+	return targetID{
+		name:            TProxyTargetName,
+		networkProtocol: tt.NetworkProtocol,
+		revision:        1,
+	}
+}
+
 type standardTargetMaker struct {
 	NetworkProtocol tcpip.NetworkProtocolNumber
 }
@@ -418,6 +447,77 @@ func (*nfNATTargetMaker) unmarshal(buf []byte, filter stack.IPHeaderFilter) (tar
 	return &target, nil
 }
 
+// tproxyTargetMaker (un)marshals revision 1 of the TPROXY target, which is
+// used for both IPv4 and IPv6.
+type tproxyTargetMaker struct {
+	NetworkProtocol tcpip.NetworkProtocolNumber
+}
+
+func (tm *tproxyTargetMaker) id() targetID {
+	return targetID{
+		name:            TProxyTargetName,
+		networkProtocol: tm.NetworkProtocol,
+		revision:        1,
+	}
+}
+
+func (*tproxyTargetMaker) marshal(target target) []byte {
+	tt := target.(*tproxyTarget)
+	xt := linux.XTTPROXYTarget{
+		Target: linux.XTEntryTarget{
+			TargetSize: linux.SizeOfXTTPROXYTarget,
+			Revision:   1,
+		},
+		Info: linux.XTTPROXYTargetInfoV1{
+			MarkMask:  tt.markMask,
+			MarkValue: tt.markValue,
+			LPort:     htons(tt.Port),
+		},
+	}
+	copy(xt.Target.Name[:], TProxyTargetName)
+	copy(xt.Info.LAddr[:], tt.Addr)
+
+	ret := make([]byte, 0, linux.SizeOfXTTPROXYTarget)
+	return binary.Marshal(ret, usermem.ByteOrder, xt)
+}
+
+func (*tproxyTargetMaker) unmarshal(buf []byte, filter stack.IPHeaderFilter) (target, *syserr.Error) {
+	if len(buf) < linux.SizeOfXTTPROXYTarget {
+		nflog("tproxyTargetMaker: buf has insufficient size for tproxy target %d", len(buf))
+		return nil, syserr.ErrInvalidArgument
+	}
+
+	// As in Linux, the target can only be used with TCP and UDP.
+	if p := filter.Protocol; p != header.TCPProtocolNumber && p != header.UDPProtocolNumber {
+		nflog("tproxyTargetMaker: bad proto %d", p)
+		return nil, syserr.ErrInvalidArgument
+	}
+
+	var xt linux.XTTPROXYTarget
+	buf = buf[:linux.SizeOfXTTPROXYTarget]
+	binary.Unmarshal(buf, usermem.ByteOrder, &xt)
+
+	netProto := filter.NetworkProtocol()
+	addrLen := header.IPv4AddressSize
+	if netProto == header.IPv6ProtocolNumber {
+		addrLen = header.IPv6AddressSize
+	}
+	target := tproxyTarget{
+		TProxyTarget: stack.TProxyTarget{
+			Port:            ntohs(xt.Info.LPort),
+			NetworkProtocol: netProto,
+		},
+		markMask:  xt.Info.MarkMask,
+		markValue: xt.Info.MarkValue,
+	}
+	// An unspecified address selects the address of the incoming interface.
+	if addr := tcpip.Address(xt.Info.LAddr[:addrLen]); addr != header.IPv4Any && addr != header.IPv6Any {
+		target.Addr = addr
+	}
+
+	return &target, nil
+}
+
 // translateToStandardTarget translates from the value in a
 // linux.XTStandardTarget to an stack.Verdict.
 func translateToStandardTarget(val int32, netProto tcpip.NetworkProtocolNumber) (target, *syserr.Error) {
@@ -0,0 +1,289 @@
+// Copyright 2020 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package audit provides a NETLINK_AUDIT socket protocol.
+//
+// It emulates enough of the Linux audit subsystem for auditd to run in the
+// sandbox. auditd can register itself and enable auditing, and userspace
+// messages, such as those of PAM, are relayed to it. While auditing is enabled
+// and auditd is registered, the sentry sends it auditd-compatible records of
+// execve, connect and syscalls that fail with EACCES or EPERM.
+//
+// Audit rules aren't supported: the records above are always generated, and
+// no others are. Audit records are only sent to auditd, since netlink sockets
+// don't support multicast groups. The audit configuration isn't saved, so
+// auditd must register itself again after restore.
+package audit
+
+import (
+	"bytes"
+	"fmt"
+
+	"gvisor.dev/gvisor/pkg/abi/linux"
+	"gvisor.dev/gvisor/pkg/context"
+	"gvisor.dev/gvisor/pkg/sentry/kernel"
+	"gvisor.dev/gvisor/pkg/sentry/kernel/auth"
+	"gvisor.dev/gvisor/pkg/sentry/socket/netlink"
+	"gvisor.dev/gvisor/pkg/sync"
+	"gvisor.dev/gvisor/pkg/syserr"
+)
+
+// auditState is the audit configuration of a kernel, shared by all of its
+// NETLINK_AUDIT sockets. It is the kernel's Auditor.
+type auditState struct {
+	// k is the kernel. It is immutable.
+	k *kernel.Kernel
+
+	// mu protects the fields below, and Protocol.sender.
+	mu sync.Mutex
+
+	// enabled is 0 if auditing is disabled, 1 if it is enabled, and 2 if it
+	// is enabled and the configuration is locked.
+	enabled uint32
+
+	// failure, rateLimit and backlogLimit are only reported back to
+	// userspace.
+	failure      uint32
+	rateLimit    uint32
+	backlogLimit uint32
+
+	// daemon is the protocol of the socket of auditd, or nil if auditd isn't
+	// registered.
+	daemon *Protocol
+
+	// daemonPID is the PID of auditd, as it registered itself.
+	daemonPID uint32
+
+	// lost is the number of records that couldn't be sent to auditd.
+	lost uint32
+
+	// serial is the serial number of the last audit event.
+	serial uint32
+}
+
+// stateOf returns the audit configuration of k, which is created with the
+// defaults of Linux the first time it is needed.
+func stateOf(k *kernel.Kernel) *auditState {
+	return k.Auditor(func() kernel.Auditor {
+		return &auditState{
+			k:            k,
+			failure:      1, // AUDIT_FAIL_PRINTK
+			backlogLimit: 64,
+		}
+	}).(*auditState)
+}
+
+// updateAuditorLocked enables the generation of audit records if auditing is
+// enabled and auditd is registered.
+//
+// Preconditions: s.mu must be locked.
+func (s *auditState) updateAuditorLocked() {
+	s.k.SetAuditEnabled(s.enabled != 0 && s.daemon != nil)
+}
+
+// Protocol implements netlink.Protocol.
+//
+// +stateify savable
+type Protocol struct {
+	// k is the kernel of the socket.
+	k *kernel.Kernel
+
+	// sender sends messages to the socket of the protocol. It is protected
+	// by the mutex of the kernel's auditState.
+	sender netlink.Sender
+}
+
+var _ netlink.SenderProtocol = (*Protocol)(nil)
+
+// NewProtocol creates a NETLINK_AUDIT netlink.Protocol.
+func NewProtocol(t *kernel.Task) (netlink.Protocol, *syserr.Error) {
+	return &Protocol{k: t.Kernel()}, nil
+}
+
+// Protocol implements netlink.Protocol.Protocol.
+func (p *Protocol) Protocol() int {
+	return linux.NETLINK_AUDIT
+}
+
+// CanSend implements netlink.Protocol.CanSend.
+func (p *Protocol) CanSend() bool {
+	return true
+}
+
+// SetSender implements netlink.SenderProtocol.SetSender.
+func (p *Protocol) SetSender(s netlink.Sender) {
+	state := stateOf(p.k)
+	state.mu.Lock()
+	defer state.mu.Unlock()
+	p.sender = s
+	if s == nil && state.daemon == p {
+		// auditd exited.
+		state.daemon = nil
+		state.daemonPID = 0
+		state.updateAuditorLocked()
+	}
+}
+
+// ProcessMessage implements netlink.Protocol.ProcessMessage.
+func (p *Protocol) ProcessMessage(ctx context.Context, msg *netlink.Message, ms *netlink.MessageSet) *syserr.Error {
+	hdr := msg.Header()
+	creds := auth.CredentialsFromContext(ctx)
+
+	switch {
+	case hdr.Type == linux.AUDIT_GET, hdr.Type == linux.AUDIT_SET, hdr.Type == linux.AUDIT_LIST_RULES, hdr.Type == linux.AUDIT_ADD_RULE, hdr.Type == linux.AUDIT_DEL_RULE:
+		if !creds.HasCapability(linux.CAP_AUDIT_CONTROL) {
+			return syserr.ErrNotPermitted
+		}
+	case isUserMessage(hdr.Type):
+		if !creds.HasCapability(linux.CAP_AUDIT_WRITE) {
+			return syserr.ErrNotPermitted
+		}
+		return p.userMessage(ctx, msg)
+	default:
+		return syserr.ErrInvalidArgument
+	}
+
+	switch hdr.Type {
+	case linux.AUDIT_GET:
+		p.getStatus(ms)
+		return nil
+	case linux.AUDIT_SET:
+		return p.setStatus(ctx, msg)
+	case linux.AUDIT_LIST_RULES:
+		// There are no rules.
+		ms.Multi = true
+		return nil
+	default:
+		return syserr.ErrNotSupported
+	}
+}
+
+// isUserMessage returns true if typ is the type of a message that userspace
+// may send to be logged.
+func isUserMessage(typ uint16) bool {
+	return typ == linux.AUDIT_USER ||
+		(typ >= linux.AUDIT_FIRST_USER_MSG && typ <= linux.AUDIT_LAST_USER_MSG) ||
+		(typ >= linux.AUDIT_FIRST_USER_MSG2 && typ <= linux.AUDIT_LAST_USER_MSG2)
+}
+
+// getStatus handles AUDIT_GET messages.
+func (p *Protocol) getStatus(ms *netlink.MessageSet) {
+	state := stateOf(p.k)
+	state.mu.Lock()
+	defer state.mu.Unlock()
+	m := ms.AddMessage(linux.NetlinkMessageHeader{
+		Type: linux.AUDIT_GET,
+	})
+	m.Put(linux.AuditStatus{
+		Enabled:      state.enabled,
+		Failure:      state.failure,
+		PID:          state.daemonPID,
+		RateLimit:    state.rateLimit,
+		BacklogLimit: state.backlogLimit,
+		Lost:         state.lost,
+	})
+}
+
+// statusSet is the leading part of struct audit_status, which holds all of
+// the fields that may be set. Older versions of userspace send shorter
+// structs.
+type statusSet struct {
+	Mask         uint32
+	Enabled      uint32
+	Failure      uint32
+	PID          uint32
+	RateLimit    uint32
+	BacklogLimit uint32
+}
+
+// setStatus handles AUDIT_SET messages.
+func (p *Protocol) setStatus(ctx context.Context, msg *netlink.Message) *syserr.Error {
+	var s statusSet
+	if _, ok := msg.GetData(&s); !ok {
+		return syserr.ErrInvalidArgument
+	}
+
+	state := stateOf(p.k)
+	state.mu.Lock()
+	defer state.mu.Unlock()
+	if state.enabled == 2 {
+		// The configuration is locked.
+		return syserr.ErrNotPermitted
+	}
+	if s.Mask&linux.AUDIT_STATUS_ENABLED != 0 && s.Enabled > 2 {
+		return syserr.ErrInvalidArgument
+	}
+	if s.Mask&linux.AUDIT_STATUS_FAILURE != 0 && s.Failure > 2 {
+		return syserr.ErrInvalidArgument
+	}
+	if s.Mask&linux.AUDIT_STATUS_PID != 0 {
+		if s.PID != 0 {
+			// auditd may only register itself.
+			t := kernel.TaskFromContext(ctx)
+			if t == nil || s.PID != uint32(t.PIDNamespace().IDOfThreadGroup(t.ThreadGroup())) {
+				return syserr.ErrInvalidArgument
+			}
+			if state.daemon != nil && state.daemon != p {
+				return syserr.ErrExists
+			}
+			state.daemon = p
+			state.daemonPID = s.PID
+		} else if state.daemon == nil || state.daemon == p {
+			state.daemon = nil
+			state.daemonPID = 0
+		} else {
+			return syserr.ErrPermissionDenied
+		}
+	}
+	if s.Mask&linux.AUDIT_STATUS_ENABLED != 0 {
+		state.enabled = s.Enabled
+	}
+	if s.Mask&linux.AUDIT_STATUS_FAILURE != 0 {
+		state.failure = s.Failure
+	}
+	if s.Mask&linux.AUDIT_STATUS_RATE_LIMIT != 0 {
+		state.rateLimit = s.RateLimit
+	}
+	if s.Mask&linux.AUDIT_STATUS_BACKLOG_LIMIT != 0 {
+		state.backlogLimit = s.BacklogLimit
+	}
+	state.updateAuditorLocked()
+	return nil
+}
+
+// userMessage handles messages that userspace sends to be logged.
+func (p *Protocol) userMessage(ctx context.Context, msg *netlink.Message) *syserr.Error {
+	t := kernel.TaskFromContext(ctx)
+	if t == nil {
+		return syserr.ErrInvalidArgument
+	}
+	// The message is text, with no header.
+	text, ok := msg.GetData(&struct{}{})
+	if !ok {
+		return syserr.ErrInvalidArgument
+	}
+	text = bytes.TrimRight(text, "\x00")
+
+	creds := t.Credentials()
+	stateOf(p.k).sendEvent(t, record{
+		typ:  msg.Header().Type,
+		text: fmt.Sprintf("pid=%d uid=%d auid=%d ses=%d msg='%s'", t.TGIDInRoot(), creds.RealKUID, unsetID, unsetID, text),
+	})
+	return nil
+}
+
+// init registers the NETLINK_AUDIT provider.
+func init() {
+	netlink.RegisterProvider(linux.NETLINK_AUDIT, NewProtocol)
+}
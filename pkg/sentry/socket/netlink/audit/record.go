@@ -0,0 +1,185 @@
+// Copyright 2020 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package audit
+
+import (
+	"fmt"
+	"strings"
+	"syscall"
+
+	"gvisor.dev/gvisor/pkg/abi/linux"
+	"gvisor.dev/gvisor/pkg/sentry/arch"
+	"gvisor.dev/gvisor/pkg/sentry/kernel"
+	"gvisor.dev/gvisor/pkg/sentry/socket/netlink"
+)
+
+const (
+	// unsetID is the value of the audit login UID and session ID, which are
+	// never set in the sandbox.
+	unsetID = ^uint32(0)
+
+	// maxArgLen and maxArgsLen limit the size of the execve arguments that
+	// are logged.
+	maxArgLen  = 4096
+	maxArgsLen = 64 * 1024
+
+	// maxSockAddrLen is the size of struct sockaddr_storage.
+	maxSockAddrLen = 128
+)
+
+// record is an audit record, which is part of an event.
+type record struct {
+	// typ is the record type, e.g. linux.AUDIT_SYSCALL.
+	typ uint16
+
+	// text is the content of the record, a series of field=value pairs.
+	text string
+}
+
+// sendEvent sends the records of an audit event to auditd, if auditing is
+// enabled and auditd is registered.
+func (s *auditState) sendEvent(t *kernel.Task, records ...record) {
+	s.mu.Lock()
+	if s.enabled == 0 || s.daemon == nil || s.daemon.sender == nil {
+		s.mu.Unlock()
+		return
+	}
+	sender := s.daemon.sender
+	s.serial++
+	serial := s.serial
+	s.mu.Unlock()
+
+	// Sending may block, so it is done without s.mu held. The records of
+	// concurrent events may thus be interleaved, which auditd handles since
+	// each record carries the serial number of its event.
+	sec, nsec := t.Kernel().RealtimeClock().Now().Unix()
+	prefix := fmt.Sprintf("audit(%d.%03d:%d): ", sec, nsec/1e6, serial)
+
+	// auditd reads a single record per datagram. Events of more than one
+	// record end with an AUDIT_EOE record.
+	if len(records) > 1 {
+		records = append(records, record{typ: linux.AUDIT_EOE})
+	}
+	var lost uint32
+	for _, r := range records {
+		ms := netlink.NewMessageSet(0 /* portID */, 0 /* seq */)
+		ms.AddMessage(linux.NetlinkMessageHeader{Type: r.typ}).Put([]byte(prefix + r.text))
+		if err := sender.Send(t, ms); err != nil {
+			lost++
+		}
+	}
+	if lost != 0 {
+		s.mu.Lock()
+		s.lost += lost
+		s.mu.Unlock()
+	}
+}
+
+// AuditSyscall implements kernel.Auditor.AuditSyscall.
+func (s *auditState) AuditSyscall(t *kernel.Task, sysno uintptr, args arch.SyscallArguments, rval uintptr, err error) {
+	errno := kernel.ExtractErrno(err, int(sysno))
+	exit := int64(rval)
+	if errno != 0 {
+		exit = -int64(errno)
+	}
+	var records []record
+	switch t.SyscallTable().LookupName(sysno) {
+	case "execve":
+		if err == nil {
+			// A successful execve doesn't return.
+			exit = 0
+			records = append(records, execveRecord(t, args[1]))
+		}
+	case "execveat":
+		if err == nil {
+			exit = 0
+			records = append(records, execveRecord(t, args[2]))
+		}
+	case "connect":
+		records = append(records, sockaddrRecord(t, args[1], args[2]))
+	default:
+		if errno != int(syscall.EACCES) && errno != int(syscall.EPERM) {
+			return
+		}
+	}
+	records = append([]record{syscallRecord(t, sysno, args, exit, errno)}, records...)
+	s.sendEvent(t, records...)
+}
+
+// syscallRecord returns the AUDIT_SYSCALL record of a syscall that returned
+// exit, and failed with errno if it is non-zero.
+func syscallRecord(t *kernel.Task, sysno uintptr, args arch.SyscallArguments, exit int64, errno int) record {
+	success := "yes"
+	if errno != 0 {
+		success = "no"
+	}
+
+	root := t.Kernel().TaskSet().Root
+	var ppid kernel.ThreadID
+	if parent := t.Parent(); parent != nil {
+		ppid = root.IDOfThreadGroup(parent.ThreadGroup())
+	}
+	creds := t.Credentials()
+	return record{
+		typ: linux.AUDIT_SYSCALL,
+		text: fmt.Sprintf("arch=%x syscall=%d success=%s exit=%d a0=%x a1=%x a2=%x a3=%x items=0 ppid=%d pid=%d auid=%d uid=%d gid=%d euid=%d suid=%d fsuid=%d egid=%d sgid=%d fsgid=%d tty=(none) ses=%d comm=%s key=(null)",
+			t.SyscallTable().AuditNumber, sysno, success, exit,
+			args[0].Uint64(), args[1].Uint64(), args[2].Uint64(), args[3].Uint64(),
+			ppid, root.IDOfThreadGroup(t.ThreadGroup()), unsetID,
+			creds.RealKUID, creds.RealKGID, creds.EffectiveKUID, creds.SavedKUID, creds.EffectiveKUID,
+			creds.EffectiveKGID, creds.SavedKGID, creds.EffectiveKGID,
+			unsetID, untrusted(t.Name())),
+	}
+}
+
+// execveRecord returns the AUDIT_EXECVE record of the argument vector at
+// argv. It must be called before the task's image is replaced.
+func execveRecord(t *kernel.Task, argv arch.SyscallArgument) record {
+	args, err := t.CopyInVector(argv.Pointer(), maxArgLen, maxArgsLen)
+	if err != nil {
+		return record{typ: linux.AUDIT_EXECVE, text: "argc=0"}
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "argc=%d", len(args))
+	for i, arg := range args {
+		fmt.Fprintf(&b, " a%d=%s", i, untrusted(arg))
+	}
+	return record{typ: linux.AUDIT_EXECVE, text: b.String()}
+}
+
+// sockaddrRecord returns the AUDIT_SOCKADDR record of the socket address at
+// addr, of length addrlen.
+func sockaddrRecord(t *kernel.Task, addr, addrlen arch.SyscallArgument) record {
+	n := int(addrlen.Uint())
+	if n < 0 || n > maxSockAddrLen {
+		n = maxSockAddrLen
+	}
+	b := make([]byte, n)
+	n, _ = t.CopyInBytes(addr.Pointer(), b)
+	return record{typ: linux.AUDIT_SOCKADDR, text: fmt.Sprintf("saddr=%X", b[:n])}
+}
+
+// untrusted returns s formatted as a value of an audit record. As in Linux,
+// strings that contain spaces, quotes or control characters are
+// hex-encoded, and others are quoted. See
+// kernel/audit.c:audit_log_n_untrustedstring.
+func untrusted(s string) string {
+	for i := 0; i < len(s); i++ {
+		if c := s[i]; c == '"' || c < 0x21 || c > 0x7e {
+			return fmt.Sprintf("%X", s)
+		}
+	}
+	return `"` + s + `"`
+}
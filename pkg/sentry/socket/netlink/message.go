@@ -187,6 +187,24 @@ func (m *Message) PutAttrString(atype uint16, s string) {
 	m.putZeros(aligned - l)
 }
 
+// NestedAttrs is the value of a nested netlink attribute, which is a series of
+// netlink attributes. It is added to a message with Message.PutAttr(atype,
+// n.Bytes()).
+type NestedAttrs struct {
+	// m holds the attributes, without a message header.
+	m Message
+}
+
+// PutAttr adds v to the nested attributes as a netlink attribute.
+func (n *NestedAttrs) PutAttr(atype uint16, v interface{}) {
+	n.m.PutAttr(atype, v)
+}
+
+// Bytes returns the serialized nested attributes.
+func (n *NestedAttrs) Bytes() []byte {
+	return n.m.buf
+}
+
 // MessageSet contains a series of netlink messages.
 type MessageSet struct {
 	// Multi indicates that this a multi-part message, to be terminated by
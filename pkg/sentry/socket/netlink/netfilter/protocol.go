@@ -0,0 +1,411 @@
+// Copyright 2020 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package netfilter provides a NETLINK_NETFILTER socket protocol.
+//
+// Only the connection tracking subsystem (ctnetlink) is supported, and only
+// for listing, deleting and counting tracked connections.
+package netfilter
+
+import (
+	"bytes"
+	"time"
+
+	"gvisor.dev/gvisor/pkg/abi/linux"
+	"gvisor.dev/gvisor/pkg/binary"
+	"gvisor.dev/gvisor/pkg/context"
+	"gvisor.dev/gvisor/pkg/sentry/inet"
+	"gvisor.dev/gvisor/pkg/sentry/kernel"
+	"gvisor.dev/gvisor/pkg/sentry/kernel/auth"
+	"gvisor.dev/gvisor/pkg/sentry/socket/netlink"
+	"gvisor.dev/gvisor/pkg/syserr"
+)
+
+// Protocol implements netlink.Protocol.
+//
+// +stateify savable
+type Protocol struct{}
+
+var _ netlink.Protocol = (*Protocol)(nil)
+
+// NewProtocol creates a NETLINK_NETFILTER netlink.Protocol.
+func NewProtocol(t *kernel.Task) (netlink.Protocol, *syserr.Error) {
+	return &Protocol{}, nil
+}
+
+// Protocol implements netlink.Protocol.Protocol.
+func (p *Protocol) Protocol() int {
+	return linux.NETLINK_NETFILTER
+}
+
+// CanSend implements netlink.Protocol.CanSend.
+func (p *Protocol) CanSend() bool {
+	return true
+}
+
+// ctMsgType returns the netlink message type of a ctnetlink message.
+func ctMsgType(typ uint16) uint16 {
+	return linux.NFNL_SUBSYS_CTNETLINK<<8 | typ
+}
+
+// be16 returns v in network byte order.
+func be16(v uint16) []byte {
+	return binary.AppendUint16(nil, binary.BigEndian, v)
+}
+
+// be32 returns v in network byte order.
+func be32(v uint32) []byte {
+	return binary.AppendUint32(nil, binary.BigEndian, v)
+}
+
+// tupleAttrs returns the nested attributes describing tuple.
+func tupleAttrs(family, protocol uint8, tuple *inet.ConnTrackTuple) []byte {
+	var ip netlink.NestedAttrs
+	if family == linux.AF_INET6 {
+		ip.PutAttr(linux.CTA_IP_V6_SRC, tuple.SrcAddr)
+		ip.PutAttr(linux.CTA_IP_V6_DST, tuple.DstAddr)
+	} else {
+		ip.PutAttr(linux.CTA_IP_V4_SRC, tuple.SrcAddr)
+		ip.PutAttr(linux.CTA_IP_V4_DST, tuple.DstAddr)
+	}
+
+	var proto netlink.NestedAttrs
+	proto.PutAttr(linux.CTA_PROTO_NUM, protocol)
+	proto.PutAttr(linux.CTA_PROTO_SRC_PORT, be16(tuple.SrcPort))
+	proto.PutAttr(linux.CTA_PROTO_DST_PORT, be16(tuple.DstPort))
+
+	var attrs netlink.NestedAttrs
+	attrs.PutAttr(linux.CTA_TUPLE_IP|linux.NLA_F_NESTED, ip.Bytes())
+	attrs.PutAttr(linux.CTA_TUPLE_PROTO|linux.NLA_F_NESTED, proto.Bytes())
+	return attrs.Bytes()
+}
+
+// addEntryMessage adds an IPCTNL_MSG_CT_NEW message describing entry to ms.
+func addEntryMessage(ms *netlink.MessageSet, entry *inet.ConnTrackEntry) {
+	m := ms.AddMessage(linux.NetlinkMessageHeader{
+		Type: ctMsgType(linux.IPCTNL_MSG_CT_NEW),
+	})
+	m.Put(linux.NFGenMsg{
+		Family:  entry.Family,
+		Version: linux.NFNETLINK_V0,
+	})
+
+	m.PutAttr(linux.CTA_TUPLE_ORIG|linux.NLA_F_NESTED, tupleAttrs(entry.Family, entry.Protocol, &entry.Original))
+	m.PutAttr(linux.CTA_TUPLE_REPLY|linux.NLA_F_NESTED, tupleAttrs(entry.Family, entry.Protocol, &entry.Reply))
+	m.PutAttr(linux.CTA_STATUS, be32(entry.Status))
+	m.PutAttr(linux.CTA_TIMEOUT, be32(uint32(entry.Timeout/time.Second)))
+	if entry.Protocol == linux.IPPROTO_TCP {
+		var tcp netlink.NestedAttrs
+		tcp.PutAttr(linux.CTA_PROTOINFO_TCP_STATE, entry.TCPState)
+		var info netlink.NestedAttrs
+		info.PutAttr(linux.CTA_PROTOINFO_TCP|linux.NLA_F_NESTED, tcp.Bytes())
+		m.PutAttr(linux.CTA_PROTOINFO|linux.NLA_F_NESTED, info.Bytes())
+	}
+	m.PutAttr(linux.CTA_USE, be32(1))
+	m.PutAttr(linux.CTA_ID, be32(entry.ID))
+}
+
+// tupleFilter describes a tuple given in a request.
+type tupleFilter struct {
+	srcAddr  []byte
+	dstAddr  []byte
+	protocol uint8
+	srcPort  uint16
+	dstPort  uint16
+
+	// hasPorts indicates that srcPort and dstPort were given.
+	hasPorts bool
+}
+
+// matches returns whether tuple of a connection of the given protocol is
+// described by f.
+func (f *tupleFilter) matches(protocol uint8, tuple *inet.ConnTrackTuple) bool {
+	if f.protocol != protocol {
+		return false
+	}
+	if !bytes.Equal(f.srcAddr, tuple.SrcAddr) || !bytes.Equal(f.dstAddr, tuple.DstAddr) {
+		return false
+	}
+	return !f.hasPorts || (f.srcPort == tuple.SrcPort && f.dstPort == tuple.DstPort)
+}
+
+// parseTuple parses the nested CTA_TUPLE_* attributes in b.
+func parseTuple(b []byte) (tupleFilter, *syserr.Error) {
+	var f tupleFilter
+	var hasProto, hasSrcPort, hasDstPort bool
+	attrs := netlink.AttrsView(b)
+	for !attrs.Empty() {
+		ahdr, value, rest, ok := attrs.ParseFirst()
+		if !ok {
+			return f, syserr.ErrInvalidArgument
+		}
+		attrs = rest
+
+		switch ahdr.Type & linux.NLA_TYPE_MASK {
+		case linux.CTA_TUPLE_IP:
+			ip := netlink.AttrsView(value)
+			for !ip.Empty() {
+				ahdr, value, rest, ok := ip.ParseFirst()
+				if !ok {
+					return f, syserr.ErrInvalidArgument
+				}
+				ip = rest
+
+				switch ahdr.Type & linux.NLA_TYPE_MASK {
+				case linux.CTA_IP_V4_SRC, linux.CTA_IP_V6_SRC:
+					f.srcAddr = value
+				case linux.CTA_IP_V4_DST, linux.CTA_IP_V6_DST:
+					f.dstAddr = value
+				}
+			}
+		case linux.CTA_TUPLE_PROTO:
+			proto := netlink.AttrsView(value)
+			for !proto.Empty() {
+				ahdr, value, rest, ok := proto.ParseFirst()
+				if !ok {
+					return f, syserr.ErrInvalidArgument
+				}
+				proto = rest
+
+				switch ahdr.Type & linux.NLA_TYPE_MASK {
+				case linux.CTA_PROTO_NUM:
+					if len(value) < 1 {
+						return f, syserr.ErrInvalidArgument
+					}
+					f.protocol = value[0]
+					hasProto = true
+				case linux.CTA_PROTO_SRC_PORT:
+					if len(value) < 2 {
+						return f, syserr.ErrInvalidArgument
+					}
+					f.srcPort = binary.BigEndian.Uint16(value)
+					hasSrcPort = true
+				case linux.CTA_PROTO_DST_PORT:
+					if len(value) < 2 {
+						return f, syserr.ErrInvalidArgument
+					}
+					f.dstPort = binary.BigEndian.Uint16(value)
+					hasDstPort = true
+				}
+			}
+		}
+	}
+
+	// Like Linux, the addresses and the protocol are mandatory.
+	if f.srcAddr == nil || f.dstAddr == nil || !hasProto {
+		return f, syserr.ErrInvalidArgument
+	}
+	if hasSrcPort != hasDstPort {
+		return f, syserr.ErrInvalidArgument
+	}
+	f.hasPorts = hasSrcPort
+	return f, nil
+}
+
+// parseRequestTuple returns the tuple given in the attributes of a
+// IPCTNL_MSG_CT_GET or IPCTNL_MSG_CT_DELETE request, or nil if there is none.
+func parseRequestTuple(attrs netlink.AttrsView) (*tupleFilter, bool, *syserr.Error) {
+	for !attrs.Empty() {
+		ahdr, value, rest, ok := attrs.ParseFirst()
+		if !ok {
+			return nil, false, syserr.ErrInvalidArgument
+		}
+		attrs = rest
+
+		switch ahdr.Type & linux.NLA_TYPE_MASK {
+		case linux.CTA_TUPLE_ORIG, linux.CTA_TUPLE_REPLY:
+			f, err := parseTuple(value)
+			if err != nil {
+				return nil, false, err
+			}
+			return &f, ahdr.Type&linux.NLA_TYPE_MASK == linux.CTA_TUPLE_REPLY, nil
+		}
+	}
+	return nil, false, nil
+}
+
+// entryMatcher returns a function matching the entries of the given family
+// (any if AF_UNSPEC) described by the tuple f (any if nil).
+func entryMatcher(family uint8, f *tupleFilter, reply bool) func(*inet.ConnTrackEntry) bool {
+	return func(entry *inet.ConnTrackEntry) bool {
+		if family != linux.AF_UNSPEC && entry.Family != family {
+			return false
+		}
+		if f == nil {
+			return true
+		}
+		if reply {
+			return f.matches(entry.Protocol, &entry.Reply)
+		}
+		return f.matches(entry.Protocol, &entry.Original)
+	}
+}
+
+// getConntrack handles IPCTNL_MSG_CT_GET requests.
+func (p *Protocol) getConntrack(ctx context.Context, stack inet.Stack, nfgen *linux.NFGenMsg, attrs netlink.AttrsView, msg *netlink.Message, ms *netlink.MessageSet) *syserr.Error {
+	dump := msg.Header().Flags&linux.NLM_F_DUMP == linux.NLM_F_DUMP
+
+	var match func(*inet.ConnTrackEntry) bool
+	if dump {
+		ms.Multi = true
+		match = entryMatcher(nfgen.Family, nil, false)
+	} else {
+		f, reply, err := parseRequestTuple(attrs)
+		if err != nil {
+			return err
+		}
+		if f == nil {
+			return syserr.ErrInvalidArgument
+		}
+		match = entryMatcher(nfgen.Family, f, reply)
+	}
+
+	entries, err := stack.ConnTrackEntries()
+	if err != nil {
+		return syserr.FromError(err)
+	}
+	for i := range entries {
+		if !match(&entries[i]) {
+			continue
+		}
+		addEntryMessage(ms, &entries[i])
+		if !dump {
+			return nil
+		}
+	}
+	if !dump {
+		return syserr.ErrNoFileOrDir
+	}
+	return nil
+}
+
+// delConntrack handles IPCTNL_MSG_CT_DELETE requests.
+func (p *Protocol) delConntrack(ctx context.Context, stack inet.Stack, nfgen *linux.NFGenMsg, attrs netlink.AttrsView, ms *netlink.MessageSet) *syserr.Error {
+	f, reply, err := parseRequestTuple(attrs)
+	if err != nil {
+		return err
+	}
+
+	// Without a tuple, all the connections of the family are flushed.
+	n, rerr := stack.RemoveConnTrackEntries(entryMatcher(nfgen.Family, f, reply))
+	if rerr != nil {
+		return syserr.FromError(rerr)
+	}
+	if f != nil && n == 0 {
+		return syserr.ErrNoFileOrDir
+	}
+	return nil
+}
+
+// getStats handles IPCTNL_MSG_CT_GET_STATS requests.
+func (p *Protocol) getStats(ctx context.Context, stack inet.Stack, ms *netlink.MessageSet) *syserr.Error {
+	stats, err := stack.ConnTrackStats()
+	if err != nil {
+		return syserr.FromError(err)
+	}
+	settings, err := stack.ConnTrackSettings()
+	if err != nil {
+		return syserr.FromError(err)
+	}
+
+	m := ms.AddMessage(linux.NetlinkMessageHeader{
+		Type: ctMsgType(linux.IPCTNL_MSG_CT_GET_STATS),
+	})
+	m.Put(linux.NFGenMsg{
+		Family:  linux.AF_UNSPEC,
+		Version: linux.NFNETLINK_V0,
+	})
+	m.PutAttr(linux.CTA_STATS_GLOBAL_ENTRIES, be32(stats.Entries))
+	m.PutAttr(linux.CTA_STATS_GLOBAL_MAX_ENTRIES, be32(settings.Max))
+	return nil
+}
+
+// dumpStatsCPU handles IPCTNL_MSG_CT_GET_STATS_CPU requests.
+//
+// The statistics aren't kept per CPU, so they are all reported as CPU 0.
+func (p *Protocol) dumpStatsCPU(ctx context.Context, stack inet.Stack, ms *netlink.MessageSet) *syserr.Error {
+	ms.Multi = true
+
+	stats, err := stack.ConnTrackStats()
+	if err != nil {
+		return syserr.FromError(err)
+	}
+
+	m := ms.AddMessage(linux.NetlinkMessageHeader{
+		Type: ctMsgType(linux.IPCTNL_MSG_CT_GET_STATS_CPU),
+	})
+	m.Put(linux.NFGenMsg{
+		Family:  linux.AF_UNSPEC,
+		Version: linux.NFNETLINK_V0,
+	})
+	m.PutAttr(linux.CTA_STATS_FOUND, be32(uint32(stats.Found)))
+	m.PutAttr(linux.CTA_STATS_INSERT, be32(uint32(stats.Insert)))
+	m.PutAttr(linux.CTA_STATS_INSERT_FAILED, be32(uint32(stats.InsertFailed)))
+	m.PutAttr(linux.CTA_STATS_DROP, be32(uint32(stats.Drop)))
+	return nil
+}
+
+// processConntrackMessage handles messages of the NFNL_SUBSYS_CTNETLINK
+// subsystem.
+func (p *Protocol) processConntrackMessage(ctx context.Context, nfgen *linux.NFGenMsg, attrs netlink.AttrsView, msg *netlink.Message, ms *netlink.MessageSet) *syserr.Error {
+	stack := inet.StackFromContext(ctx)
+	if stack == nil {
+		// No network stack, so nothing is tracked.
+		return syserr.ErrNotSupported
+	}
+
+	switch linux.NFNLMsgType(msg.Header().Type) {
+	case linux.IPCTNL_MSG_CT_GET:
+		return p.getConntrack(ctx, stack, nfgen, attrs, msg, ms)
+	case linux.IPCTNL_MSG_CT_DELETE:
+		return p.delConntrack(ctx, stack, nfgen, attrs, ms)
+	case linux.IPCTNL_MSG_CT_GET_STATS:
+		return p.getStats(ctx, stack, ms)
+	case linux.IPCTNL_MSG_CT_GET_STATS_CPU:
+		return p.dumpStatsCPU(ctx, stack, ms)
+	default:
+		// TODO(gvisor.dev/issue/170): Creating and updating connections
+		// isn't supported.
+		return syserr.ErrNotSupported
+	}
+}
+
+// ProcessMessage implements netlink.Protocol.ProcessMessage.
+func (p *Protocol) ProcessMessage(ctx context.Context, msg *netlink.Message, ms *netlink.MessageSet) *syserr.Error {
+	// Like Linux, all the messages require CAP_NET_ADMIN. See
+	// net/netfilter/nfnetlink.c:nfnetlink_rcv.
+	creds := auth.CredentialsFromContext(ctx)
+	if !creds.HasCapability(linux.CAP_NET_ADMIN) {
+		return syserr.ErrPermissionDenied
+	}
+
+	// All messages start with a nfgenmsg.
+	var nfgen linux.NFGenMsg
+	attrs, ok := msg.GetData(&nfgen)
+	if !ok {
+		return syserr.ErrInvalidArgument
+	}
+
+	switch linux.NFNLSubsysID(msg.Header().Type) {
+	case linux.NFNL_SUBSYS_CTNETLINK:
+		return p.processConntrackMessage(ctx, &nfgen, attrs, msg, ms)
+	default:
+		return syserr.ErrInvalidArgument
+	}
+}
+
+// init registers the NETLINK_NETFILTER provider.
+func init() {
+	netlink.RegisterProvider(linux.NETLINK_NETFILTER, NewProtocol)
+}
@@ -45,6 +45,23 @@ type Protocol interface {
 	ProcessMessage(ctx context.Context, msg *Message, ms *MessageSet) *syserr.Error
 }
 
+// Sender sends messages to a netlink socket.
+type Sender interface {
+	// Send sends the messages in ms to the socket in a single datagram. As
+	// on Linux, they are dropped if the socket's receive buffer is full.
+	Send(ctx context.Context, ms *MessageSet) *syserr.Error
+}
+
+// SenderProtocol is implemented by Protocols that send messages to their
+// socket other than in response to its requests, e.g. to report events.
+type SenderProtocol interface {
+	Protocol
+
+	// SetSender is called with the socket of the protocol when it is
+	// created, and with nil when it is released.
+	SetSender(s Sender)
+}
+
 // Provider is a function that creates a new Protocol for a specific netlink
 // protocol.
 //
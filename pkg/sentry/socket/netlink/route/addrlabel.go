@@ -0,0 +1,153 @@
+// Copyright 2021 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package route
+
+import (
+	"gvisor.dev/gvisor/pkg/abi/linux"
+	"gvisor.dev/gvisor/pkg/context"
+	"gvisor.dev/gvisor/pkg/sentry/inet"
+	"gvisor.dev/gvisor/pkg/sentry/socket/netlink"
+	"gvisor.dev/gvisor/pkg/syserr"
+)
+
+// dumpAddrLabels handles RTM_GETADDRLABEL dump requests.
+func (p *Protocol) dumpAddrLabels(ctx context.Context, msg *netlink.Message, ms *netlink.MessageSet) *syserr.Error {
+	// We always send back an NLMSG_DONE.
+	ms.Multi = true
+
+	stack := inet.StackFromContext(ctx)
+	if stack == nil {
+		// No network stack.
+		return nil
+	}
+
+	var family uint8
+	msg.GetData(&family)
+	if family != linux.AF_UNSPEC && family != linux.AF_INET6 {
+		return nil
+	}
+
+	labels, err := stack.AddressLabels()
+	if err != nil {
+		// The stack does not support IPv6.
+		return nil
+	}
+	for _, l := range labels {
+		m := ms.AddMessage(linux.NetlinkMessageHeader{
+			Type: linux.RTM_NEWADDRLABEL,
+		})
+
+		m.Put(linux.IfAddrLblMessage{
+			Family:    linux.AF_INET6,
+			PrefixLen: l.PrefixLen,
+			Index:     uint32(l.Interface),
+		})
+		m.PutAttr(linux.IFAL_ADDRESS, l.Prefix)
+		m.PutAttr(linux.IFAL_LABEL, l.Label)
+	}
+
+	return nil
+}
+
+// parseAddrLabel parses a message as format of IfAddrLblMessage-RtAttr for the
+// RTM_NEWADDRLABEL and RTM_DELADDRLABEL requests.
+func parseAddrLabel(stack inet.Stack, msg *netlink.Message) (inet.AddressLabel, bool, *syserr.Error) {
+	var ifal linux.IfAddrLblMessage
+	attrs, ok := msg.GetData(&ifal)
+	if !ok {
+		return inet.AddressLabel{}, false, syserr.ErrInvalidArgument
+	}
+	if ifal.Family != linux.AF_INET6 {
+		return inet.AddressLabel{}, false, syserr.ErrInvalidArgument
+	}
+	if ifal.Index != 0 {
+		if _, ok := stack.Interfaces()[int32(ifal.Index)]; !ok {
+			return inet.AddressLabel{}, false, syserr.ErrInvalidArgument
+		}
+	}
+
+	label := inet.AddressLabel{
+		PrefixLen: ifal.PrefixLen,
+		Interface: int32(ifal.Index),
+	}
+	hasLabel := false
+	for !attrs.Empty() {
+		ahdr, value, rest, ok := attrs.ParseFirst()
+		if !ok {
+			return inet.AddressLabel{}, false, syserr.ErrInvalidArgument
+		}
+		attrs = rest
+
+		switch ahdr.Type {
+		case linux.IFAL_ADDRESS:
+			label.Prefix = value
+		case linux.IFAL_LABEL:
+			v, ok := parseUint32Attr(value)
+			if !ok {
+				return inet.AddressLabel{}, false, syserr.ErrInvalidArgument
+			}
+			label.Label = v
+			hasLabel = true
+		default:
+			return inet.AddressLabel{}, false, syserr.ErrNotSupported
+		}
+	}
+
+	if len(label.Prefix) != 16 || label.PrefixLen > 128 {
+		return inet.AddressLabel{}, false, syserr.ErrInvalidArgument
+	}
+	return label, hasLabel, nil
+}
+
+// newAddrLabel handles RTM_NEWADDRLABEL requests.
+func (p *Protocol) newAddrLabel(ctx context.Context, msg *netlink.Message, ms *netlink.MessageSet) *syserr.Error {
+	stack := inet.StackFromContext(ctx)
+	if stack == nil {
+		// No network stack.
+		return syserr.ErrProtocolNotSupported
+	}
+
+	label, hasLabel, err := parseAddrLabel(stack, msg)
+	if err != nil {
+		return err
+	}
+	if !hasLabel || label.Label == linux.IPV6_ADDR_LABEL_DEFAULT {
+		return syserr.ErrInvalidArgument
+	}
+
+	replace := msg.Header().Flags&linux.NLM_F_REPLACE != 0
+	if err := stack.AddAddressLabel(label, replace); err != nil {
+		return syserr.FromError(err)
+	}
+	return nil
+}
+
+// delAddrLabel handles RTM_DELADDRLABEL requests.
+func (p *Protocol) delAddrLabel(ctx context.Context, msg *netlink.Message, ms *netlink.MessageSet) *syserr.Error {
+	stack := inet.StackFromContext(ctx)
+	if stack == nil {
+		// No network stack.
+		return syserr.ErrProtocolNotSupported
+	}
+
+	label, _, err := parseAddrLabel(stack, msg)
+	if err != nil {
+		return err
+	}
+	if err := stack.RemoveAddressLabel(label); err != nil {
+		return syserr.FromError(err)
+	}
+	return nil
+}
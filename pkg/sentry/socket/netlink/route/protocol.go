@@ -26,6 +26,8 @@ import (
 	"gvisor.dev/gvisor/pkg/sentry/kernel/auth"
 	"gvisor.dev/gvisor/pkg/sentry/socket/netlink"
 	"gvisor.dev/gvisor/pkg/syserr"
+	"gvisor.dev/gvisor/pkg/syserror"
+	"gvisor.dev/gvisor/pkg/usermem"
 )
 
 // commandKind describes the operational class of a message type.
@@ -134,29 +136,135 @@ func (p *Protocol) getLink(ctx context.Context, msg *netlink.Message, ms *netlin
 		}
 	}
 
-	found := false
+	idx, i, err := findInterface(stack, ifi.Index, byName)
+	if err != nil {
+		return err
+	}
+	addNewLinkMessage(ms, idx, i)
+	return nil
+}
+
+// findInterface returns the interface identified by index if it is positive,
+// or by name otherwise.
+func findInterface(stack inet.Stack, index int32, name []byte) (int32, inet.Interface, *syserr.Error) {
 	for idx, i := range stack.Interfaces() {
 		switch {
-		case ifi.Index > 0:
-			if idx != ifi.Index {
+		case index > 0:
+			if idx != index {
 				continue
 			}
-		case byName != nil:
-			if string(byName) != i.Name {
+		case name != nil:
+			if string(name) != i.Name {
 				continue
 			}
 		default:
 			// Criteria not specified.
+			return 0, inet.Interface{}, syserr.ErrInvalidArgument
+		}
+
+		return idx, i, nil
+	}
+	return 0, inet.Interface{}, syserr.ErrNoDevice
+}
+
+// setLink handles RTM_NEWLINK and RTM_SETLINK requests for existing
+// interfaces.
+func (p *Protocol) setLink(ctx context.Context, msg *netlink.Message, ms *netlink.MessageSet) *syserr.Error {
+	stack := inet.StackFromContext(ctx)
+	if stack == nil {
+		// No network stack.
+		return syserr.ErrProtocolNotSupported
+	}
+
+	var ifi linux.InterfaceInfoMessage
+	attrs, ok := msg.GetData(&ifi)
+	if !ok {
+		return syserr.ErrInvalidArgument
+	}
+
+	var (
+		byName   []byte
+		hwAddr   []byte
+		mtu      uint32
+		setMTU   bool
+		linkInfo []byte
+	)
+	for !attrs.Empty() {
+		ahdr, value, rest, ok := attrs.ParseFirst()
+		if !ok {
 			return syserr.ErrInvalidArgument
 		}
+		attrs = rest
 
-		addNewLinkMessage(ms, idx, i)
-		found = true
-		break
+		switch ahdr.Type {
+		case linux.IFLA_IFNAME:
+			if len(value) < 1 {
+				return syserr.ErrInvalidArgument
+			}
+			byName = value[:len(value)-1]
+		case linux.IFLA_MTU:
+			if mtu, ok = parseUint32Attr(value); !ok {
+				return syserr.ErrInvalidArgument
+			}
+			setMTU = true
+		case linux.IFLA_ADDRESS:
+			hwAddr = value
+		case linux.IFLA_LINKINFO:
+			linkInfo = value
+		case linux.IFLA_MASTER, linux.IFLA_NET_NS_PID, linux.IFLA_NET_NS_FD:
+			// Moving links is not supported.
+			return syserr.ErrNotSupported
+		}
 	}
-	if !found {
-		return syserr.ErrNoDevice
+
+	idx, i, err := findInterface(stack, ifi.Index, byName)
+	if err == syserr.ErrNoDevice && msg.Header().Flags&linux.NLM_F_CREATE != 0 {
+		if linkInfo == nil || ifi.Index > 0 {
+			// Only tunnel links can be created, and their index can't be
+			// chosen.
+			return syserr.ErrNotSupported
+		}
+		return newLink(stack, byName, hwAddr, mtu, linkInfo)
+	}
+	if err != nil {
+		return err
+	}
+	if msg.Header().Flags&linux.NLM_F_EXCL != 0 {
+		return syserr.ErrExists
+	}
+	if linkInfo != nil {
+		// The configuration of links cannot be changed.
+		return syserr.ErrNotSupported
 	}
+	if ifi.Index > 0 && byName != nil && string(byName) != i.Name {
+		// Links cannot be renamed.
+		return syserr.ErrNotSupported
+	}
+	if hwAddr != nil && !bytes.Equal(hwAddr, i.Addr) {
+		// The hardware address cannot be changed.
+		return syserr.ErrNotSupported
+	}
+
+	if setMTU && mtu != i.MTU {
+		if err := stack.SetInterfaceMTU(idx, mtu); err != nil {
+			return syserr.ErrInvalidArgument
+		}
+	}
+
+	// As in net/core/rtnetlink.c:do_setlink, the flags are only changed if
+	// any flag or change mask is specified.
+	if ifi.Flags != 0 || ifi.Change != 0 {
+		flags := ifi.Flags
+		if ifi.Change != 0 {
+			flags = (ifi.Flags & ifi.Change) | (i.Flags &^ ifi.Change)
+		}
+		if up := flags&linux.IFF_UP != 0; up != (i.Flags&linux.IFF_UP != 0) {
+			if err := stack.SetInterfaceUp(idx, up); err != nil {
+				return syserr.FromError(err)
+			}
+		}
+	}
+
 	return nil
 }
 
@@ -186,6 +294,10 @@ func addNewLinkMessage(ms *netlink.MessageSet, idx int32, i inet.Interface) {
 	m.PutAttr(linux.IFLA_ADDRESS, mac)
 	m.PutAttr(linux.IFLA_BROADCAST, brd)
 
+	if i.Tunnel != nil {
+		putLinkInfo(m, i.Tunnel)
+	}
+
 	// TODO(gvisor.dev/issue/578): There are many more attributes.
 }
 
@@ -366,15 +478,16 @@ func (p *Protocol) dumpRoutes(ctx context.Context, msg *netlink.Message, ms *net
 			Type: linux.RTM_NEWROUTE,
 		})
 
+		table := rt.Table
+		if table == linux.RT_TABLE_UNSPEC {
+			table = linux.RT_TABLE_MAIN
+		}
 		m.Put(linux.RouteMessage{
-			Family: rt.Family,
-			DstLen: rt.DstLen,
-			SrcLen: rt.SrcLen,
-			TOS:    rt.TOS,
-
-			// Always return the main table since we don't have multiple
-			// routing tables.
-			Table:    linux.RT_TABLE_MAIN,
+			Family:   rt.Family,
+			DstLen:   rt.DstLen,
+			SrcLen:   rt.SrcLen,
+			TOS:      rt.TOS,
+			Table:    compatTable(table),
 			Protocol: rt.Protocol,
 			Scope:    rt.Scope,
 			Type:     rt.Type,
@@ -383,6 +496,7 @@ func (p *Protocol) dumpRoutes(ctx context.Context, msg *netlink.Message, ms *net
 		})
 
 		m.PutAttr(254, []byte{123})
+		m.PutAttr(linux.RTA_TABLE, table)
 		if rt.DstLen > 0 {
 			m.PutAttr(linux.RTA_DST, rt.DstAddr)
 		}
@@ -402,6 +516,533 @@ func (p *Protocol) dumpRoutes(ctx context.Context, msg *netlink.Message, ms *net
 	return nil
 }
 
+// parseUint32Attr parses the value of an attribute holding a 32-bit integer.
+func parseUint32Attr(value []byte) (uint32, bool) {
+	if len(value) < 4 {
+		return 0, false
+	}
+	return usermem.ByteOrder.Uint32(value), true
+}
+
+// parseRoute parses a message as format of RouteMessage-RtAttr for the
+// RTM_NEWROUTE and RTM_DELROUTE requests.
+func parseRoute(msg *netlink.Message) (inet.Route, *syserr.Error) {
+	var rtMsg linux.RouteMessage
+	attrs, ok := msg.GetData(&rtMsg)
+	if !ok {
+		return inet.Route{}, syserr.ErrInvalidArgument
+	}
+	route := inet.Route{
+		Family:   rtMsg.Family,
+		DstLen:   rtMsg.DstLen,
+		SrcLen:   rtMsg.SrcLen,
+		TOS:      rtMsg.TOS,
+		Protocol: rtMsg.Protocol,
+		Scope:    rtMsg.Scope,
+		Type:     rtMsg.Type,
+		Flags:    rtMsg.Flags,
+	}
+	table := uint32(rtMsg.Table)
+
+	for !attrs.Empty() {
+		ahdr, value, rest, ok := attrs.ParseFirst()
+		if !ok {
+			return inet.Route{}, syserr.ErrInvalidArgument
+		}
+		attrs = rest
+
+		switch ahdr.Type {
+		case linux.RTA_DST:
+			route.DstAddr = value
+		case linux.RTA_SRC:
+			route.SrcAddr = value
+		case linux.RTA_GATEWAY:
+			route.GatewayAddr = value
+		case linux.RTA_OIF:
+			oif, ok := parseUint32Attr(value)
+			if !ok {
+				return inet.Route{}, syserr.ErrInvalidArgument
+			}
+			route.OutputInterface = int32(oif)
+		case linux.RTA_TABLE:
+			if table, ok = parseUint32Attr(value); !ok {
+				return inet.Route{}, syserr.ErrInvalidArgument
+			}
+		case linux.RTA_PRIORITY, linux.RTA_PREFSRC, linux.RTA_METRICS, linux.RTA_PREF, linux.RTA_CACHEINFO:
+			// Route metrics and preferences are not supported; all routes
+			// are equally preferred.
+		default:
+			return inet.Route{}, syserr.ErrNotSupported
+		}
+	}
+
+	if table == linux.RT_TABLE_UNSPEC {
+		table = linux.RT_TABLE_MAIN
+	}
+	route.Table = table
+
+	// Only unicast routes to a destination are supported.
+	if route.Type != linux.RTN_UNSPEC && route.Type != linux.RTN_UNICAST {
+		return inet.Route{}, syserr.ErrNotSupported
+	}
+	if route.SrcLen != 0 || route.TOS != 0 {
+		return inet.Route{}, syserr.ErrNotSupported
+	}
+
+	return route, nil
+}
+
+// newRoute handles RTM_NEWROUTE requests.
+func (p *Protocol) newRoute(ctx context.Context, msg *netlink.Message, ms *netlink.MessageSet) *syserr.Error {
+	stack := inet.StackFromContext(ctx)
+	if stack == nil {
+		// No network stack.
+		return syserr.ErrProtocolNotSupported
+	}
+
+	route, err := parseRoute(msg)
+	if err != nil {
+		return err
+	}
+
+	flags := msg.Header().Flags
+	if flags&linux.NLM_F_REPLACE != 0 {
+		// Replace the routes to the same destination.
+		err := stack.RemoveRoute(inet.Route{
+			Family:  route.Family,
+			DstLen:  route.DstLen,
+			Table:   route.Table,
+			DstAddr: route.DstAddr,
+		})
+		if err == syserror.ESRCH {
+			if flags&linux.NLM_F_CREATE == 0 {
+				return syserr.ErrNoFileOrDir
+			}
+		} else if err != nil {
+			return syserr.FromError(err)
+		}
+	}
+
+	if err := stack.AddRoute(route); err != nil {
+		return syserr.FromError(err)
+	}
+	return nil
+}
+
+// delRoute handles RTM_DELROUTE requests.
+func (p *Protocol) delRoute(ctx context.Context, msg *netlink.Message, ms *netlink.MessageSet) *syserr.Error {
+	stack := inet.StackFromContext(ctx)
+	if stack == nil {
+		// No network stack.
+		return syserr.ErrProtocolNotSupported
+	}
+
+	route, err := parseRoute(msg)
+	if err != nil {
+		return err
+	}
+
+	if err := stack.RemoveRoute(route); err != nil {
+		return syserr.FromError(err)
+	}
+	return nil
+}
+
+// dumpNeighbors handles RTM_GETNEIGH dump requests.
+func (p *Protocol) dumpNeighbors(ctx context.Context, msg *netlink.Message, ms *netlink.MessageSet) *syserr.Error {
+	// The RTM_GETNEIGH dump response is a set of RTM_NEWNEIGH messages each
+	// containing a NeighborMessage followed by a set of netlink attributes.
+
+	// We always send back an NLMSG_DONE.
+	ms.Multi = true
+
+	stack := inet.StackFromContext(ctx)
+	if stack == nil {
+		// No network devices.
+		return nil
+	}
+
+	var family uint8
+	msg.GetData(&family)
+
+	for idx, ns := range stack.Neighbors() {
+		for _, n := range ns {
+			if family != linux.AF_UNSPEC && family != n.Family {
+				continue
+			}
+
+			m := ms.AddMessage(linux.NetlinkMessageHeader{
+				Type: linux.RTM_NEWNEIGH,
+			})
+
+			m.Put(linux.NeighborMessage{
+				Family:  n.Family,
+				Ifindex: idx,
+				State:   n.State,
+				Type:    linux.RTN_UNICAST,
+			})
+
+			m.PutAttr(linux.NDA_DST, n.Addr)
+			if len(n.LinkAddr) > 0 {
+				m.PutAttr(linux.NDA_LLADDR, n.LinkAddr)
+			}
+		}
+	}
+
+	return nil
+}
+
+// parseNeighbor parses a message as format of NeighborMessage-RtAttr for the
+// RTM_NEWNEIGH and RTM_DELNEIGH requests.
+func parseNeighbor(stack inet.Stack, msg *netlink.Message) (int32, inet.Neighbor, *syserr.Error) {
+	var ndm linux.NeighborMessage
+	attrs, ok := msg.GetData(&ndm)
+	if !ok {
+		return 0, inet.Neighbor{}, syserr.ErrInvalidArgument
+	}
+	neigh := inet.Neighbor{
+		Family: ndm.Family,
+		State:  ndm.State,
+	}
+
+	for !attrs.Empty() {
+		ahdr, value, rest, ok := attrs.ParseFirst()
+		if !ok {
+			return 0, inet.Neighbor{}, syserr.ErrInvalidArgument
+		}
+		attrs = rest
+
+		switch ahdr.Type {
+		case linux.NDA_DST:
+			neigh.Addr = value
+		case linux.NDA_LLADDR:
+			neigh.LinkAddr = value
+		}
+	}
+
+	if len(neigh.Addr) == 0 {
+		return 0, inet.Neighbor{}, syserr.ErrInvalidArgument
+	}
+	if _, ok := stack.Interfaces()[ndm.Ifindex]; !ok {
+		return 0, inet.Neighbor{}, syserr.ErrNoDevice
+	}
+	return ndm.Ifindex, neigh, nil
+}
+
+// newNeighbor handles RTM_NEWNEIGH requests.
+//
+// All neighbor entries added this way are permanent, regardless of the
+// requested state.
+func (p *Protocol) newNeighbor(ctx context.Context, msg *netlink.Message, ms *netlink.MessageSet) *syserr.Error {
+	stack := inet.StackFromContext(ctx)
+	if stack == nil {
+		// No network stack.
+		return syserr.ErrProtocolNotSupported
+	}
+
+	idx, neigh, err := parseNeighbor(stack, msg)
+	if err != nil {
+		return err
+	}
+
+	if msg.Header().Flags&linux.NLM_F_EXCL != 0 {
+		for _, n := range stack.Neighbors()[idx] {
+			if bytes.Equal(n.Addr, neigh.Addr) {
+				return syserr.ErrExists
+			}
+		}
+	}
+
+	if err := stack.AddNeighbor(idx, neigh); err != nil {
+		return syserr.FromError(err)
+	}
+	return nil
+}
+
+// delNeighbor handles RTM_DELNEIGH requests.
+func (p *Protocol) delNeighbor(ctx context.Context, msg *netlink.Message, ms *netlink.MessageSet) *syserr.Error {
+	stack := inet.StackFromContext(ctx)
+	if stack == nil {
+		// No network stack.
+		return syserr.ErrProtocolNotSupported
+	}
+
+	idx, neigh, err := parseNeighbor(stack, msg)
+	if err != nil {
+		return err
+	}
+
+	if err := stack.RemoveNeighbor(idx, neigh); err != nil {
+		return syserr.FromError(err)
+	}
+	return nil
+}
+
+// compatTable returns the value of the 8-bit table field of route and rule
+// messages for table. Larger tables are only reported as attributes.
+func compatTable(table uint32) uint8 {
+	if table > 0xff {
+		return linux.RT_TABLE_COMPAT
+	}
+	return uint8(table)
+}
+
+// dumpRules handles RTM_GETRULE dump requests.
+func (p *Protocol) dumpRules(ctx context.Context, msg *netlink.Message, ms *netlink.MessageSet) *syserr.Error {
+	// We always send back an NLMSG_DONE.
+	ms.Multi = true
+
+	stack := inet.StackFromContext(ctx)
+	if stack == nil {
+		// No network stack.
+		return nil
+	}
+
+	var family uint8
+	msg.GetData(&family)
+
+	ifaces := stack.Interfaces()
+	for _, r := range stack.RouteRules() {
+		if family != linux.AF_UNSPEC && family != r.Family {
+			continue
+		}
+
+		m := ms.AddMessage(linux.NetlinkMessageHeader{
+			Type: linux.RTM_NEWRULE,
+		})
+
+		m.Put(linux.FibRuleHdr{
+			Family: r.Family,
+			DstLen: r.DstLen,
+			SrcLen: r.SrcLen,
+			Table:  compatTable(r.Table),
+			Action: linux.FR_ACT_TO_TBL,
+			Flags:  r.Flags,
+		})
+
+		m.PutAttr(linux.FRA_TABLE, r.Table)
+		if r.Priority != 0 {
+			m.PutAttr(linux.FRA_PRIORITY, r.Priority)
+		}
+		if r.DstLen != 0 {
+			m.PutAttr(linux.FRA_DST, r.DstAddr)
+		}
+		if r.SrcLen != 0 {
+			m.PutAttr(linux.FRA_SRC, r.SrcAddr)
+		}
+		if r.Mark != 0 || r.MarkMask != 0 {
+			m.PutAttr(linux.FRA_FWMARK, r.Mark)
+			m.PutAttr(linux.FRA_FWMASK, r.MarkMask)
+		}
+		if i, ok := ifaces[r.InputInterface]; ok && r.InputInterface != 0 {
+			m.PutAttrString(linux.FRA_IIFNAME, i.Name)
+		}
+		if i, ok := ifaces[r.OutputInterface]; ok && r.OutputInterface != 0 {
+			m.PutAttrString(linux.FRA_OIFNAME, i.Name)
+		}
+		m.PutAttr(linux.FRA_SUPPRESS_PREFIXLEN, uint32(r.SuppressPrefixLen))
+	}
+
+	return nil
+}
+
+// parseRule parses a message as format of FibRuleHdr-RtAttr for the
+// RTM_NEWRULE and RTM_DELRULE requests.
+//
+// The returned attrs are a bitmask of the attributes present in the request,
+// indexed by attribute type.
+func parseRule(stack inet.Stack, msg *netlink.Message) (inet.Rule, uint64, *syserr.Error) {
+	var frh linux.FibRuleHdr
+	attrs, ok := msg.GetData(&frh)
+	if !ok {
+		return inet.Rule{}, 0, syserr.ErrInvalidArgument
+	}
+	rule := inet.Rule{
+		Family: frh.Family,
+		DstLen: frh.DstLen,
+		SrcLen: frh.SrcLen,
+		Flags:  frh.Flags,
+		Table:  uint32(frh.Table),
+
+		SuppressPrefixLen: -1,
+	}
+
+	var present uint64
+	for !attrs.Empty() {
+		ahdr, value, rest, ok := attrs.ParseFirst()
+		if !ok {
+			return inet.Rule{}, 0, syserr.ErrInvalidArgument
+		}
+		attrs = rest
+
+		switch ahdr.Type {
+		case linux.FRA_DST:
+			rule.DstAddr = value
+		case linux.FRA_SRC:
+			rule.SrcAddr = value
+		case linux.FRA_PRIORITY, linux.FRA_TABLE, linux.FRA_FWMARK, linux.FRA_FWMASK:
+			v, ok := parseUint32Attr(value)
+			if !ok {
+				return inet.Rule{}, 0, syserr.ErrInvalidArgument
+			}
+			switch ahdr.Type {
+			case linux.FRA_PRIORITY:
+				rule.Priority = v
+			case linux.FRA_TABLE:
+				rule.Table = v
+			case linux.FRA_FWMARK:
+				rule.Mark = v
+			case linux.FRA_FWMASK:
+				rule.MarkMask = v
+			}
+		case linux.FRA_IIFNAME, linux.FRA_OIFNAME:
+			if len(value) < 1 {
+				return inet.Rule{}, 0, syserr.ErrInvalidArgument
+			}
+			// Rules for interfaces that do not exist yet are not supported.
+			idx, _, err := findInterface(stack, 0, value[:len(value)-1])
+			if err != nil {
+				return inet.Rule{}, 0, err
+			}
+			if ahdr.Type == linux.FRA_IIFNAME {
+				rule.InputInterface = idx
+			} else {
+				rule.OutputInterface = idx
+			}
+		case linux.FRA_SUPPRESS_PREFIXLEN:
+			v, ok := parseUint32Attr(value)
+			if !ok {
+				return inet.Rule{}, 0, syserr.ErrInvalidArgument
+			}
+			rule.SuppressPrefixLen = int32(v)
+		case linux.FRA_SUPPRESS_IFGROUP:
+			// Interface groups are not supported; only the unset value is
+			// accepted.
+			if v, ok := parseUint32Attr(value); !ok || v != ^uint32(0) {
+				return inet.Rule{}, 0, syserr.ErrNotSupported
+			}
+		default:
+			return inet.Rule{}, 0, syserr.ErrNotSupported
+		}
+		if ahdr.Type < 64 {
+			present |= 1 << ahdr.Type
+		}
+	}
+
+	// Only rules selecting a table are supported.
+	if frh.Action != linux.FR_ACT_UNSPEC && frh.Action != linux.FR_ACT_TO_TBL {
+		return inet.Rule{}, 0, syserr.ErrNotSupported
+	}
+	if frh.TOS != 0 || rule.Flags&^linux.FIB_RULE_INVERT != 0 {
+		return inet.Rule{}, 0, syserr.ErrNotSupported
+	}
+	// As in Linux, a mark without a mask matches the whole mark.
+	if present&(1<<linux.FRA_FWMASK) == 0 && rule.Mark != 0 {
+		rule.MarkMask = ^uint32(0)
+	}
+	return rule, present, nil
+}
+
+// newRule handles RTM_NEWRULE requests.
+func (p *Protocol) newRule(ctx context.Context, msg *netlink.Message, ms *netlink.MessageSet) *syserr.Error {
+	stack := inet.StackFromContext(ctx)
+	if stack == nil {
+		// No network stack.
+		return syserr.ErrProtocolNotSupported
+	}
+
+	rule, present, err := parseRule(stack, msg)
+	if err != nil {
+		return err
+	}
+	if rule.Table == linux.RT_TABLE_UNSPEC {
+		return syserr.ErrInvalidArgument
+	}
+
+	rules := stack.RouteRules()
+	if present&(1<<linux.FRA_PRIORITY) == 0 {
+		// As in Linux, use the priority right below the one of the first
+		// rule after the rules with priority 0.
+		for _, r := range rules {
+			if r.Family == rule.Family && r.Priority != 0 {
+				rule.Priority = r.Priority - 1
+				break
+			}
+		}
+	}
+
+	if msg.Header().Flags&linux.NLM_F_EXCL != 0 {
+		for _, r := range rules {
+			if ruleMatches(r, rule, ^uint64(0)) {
+				return syserr.ErrExists
+			}
+		}
+	}
+
+	if err := stack.AddRouteRule(rule); err != nil {
+		return syserr.FromError(err)
+	}
+	return nil
+}
+
+// ruleMatches returns true if r has the selectors and table of want that are
+// present in the attrs bitmask. The header fields always match.
+func ruleMatches(r, want inet.Rule, present uint64) bool {
+	has := func(attr int) bool {
+		return present&(1<<attr) != 0
+	}
+	switch {
+	case r.Family != want.Family, r.DstLen != want.DstLen, r.SrcLen != want.SrcLen, r.Flags != want.Flags:
+		return false
+	case has(linux.FRA_PRIORITY) && r.Priority != want.Priority:
+		return false
+	case (has(linux.FRA_TABLE) || want.Table != linux.RT_TABLE_UNSPEC) && r.Table != want.Table:
+		return false
+	case has(linux.FRA_DST) && !bytes.Equal(r.DstAddr, want.DstAddr):
+		return false
+	case has(linux.FRA_SRC) && !bytes.Equal(r.SrcAddr, want.SrcAddr):
+		return false
+	case has(linux.FRA_FWMARK) && r.Mark != want.Mark:
+		return false
+	case has(linux.FRA_FWMASK) && r.MarkMask != want.MarkMask:
+		return false
+	case has(linux.FRA_IIFNAME) && r.InputInterface != want.InputInterface:
+		return false
+	case has(linux.FRA_OIFNAME) && r.OutputInterface != want.OutputInterface:
+		return false
+	case has(linux.FRA_SUPPRESS_PREFIXLEN) && r.SuppressPrefixLen != want.SuppressPrefixLen:
+		return false
+	}
+	return true
+}
+
+// delRule handles RTM_DELRULE requests.
+//
+// As in Linux, the first rule matching the attributes of the request is
+// removed.
+func (p *Protocol) delRule(ctx context.Context, msg *netlink.Message, ms *netlink.MessageSet) *syserr.Error {
+	stack := inet.StackFromContext(ctx)
+	if stack == nil {
+		// No network stack.
+		return syserr.ErrProtocolNotSupported
+	}
+
+	rule, present, err := parseRule(stack, msg)
+	if err != nil {
+		return err
+	}
+
+	for _, r := range stack.RouteRules() {
+		if ruleMatches(r, rule, present) {
+			if err := stack.RemoveRouteRule(r); err != nil {
+				return syserr.FromError(err)
+			}
+			return nil
+		}
+	}
+	return syserr.ErrNoFileOrDir
+}
+
 // newAddr handles RTM_NEWADDR requests.
 func (p *Protocol) newAddr(ctx context.Context, msg *netlink.Message, ms *netlink.MessageSet) *syserr.Error {
 	stack := inet.StackFromContext(ctx)
@@ -528,6 +1169,14 @@ func (p *Protocol) ProcessMessage(ctx context.Context, msg *netlink.Message, ms
 			return p.dumpAddrs(ctx, msg, ms)
 		case linux.RTM_GETROUTE:
 			return p.dumpRoutes(ctx, msg, ms)
+		case linux.RTM_GETNEIGH:
+			return p.dumpNeighbors(ctx, msg, ms)
+		case linux.RTM_GETRULE:
+			return p.dumpRules(ctx, msg, ms)
+		case linux.RTM_GETQDISC:
+			return p.dumpQdiscs(ctx, msg, ms)
+		case linux.RTM_GETADDRLABEL:
+			return p.dumpAddrLabels(ctx, msg, ms)
 		default:
 			return syserr.ErrNotSupported
 		}
@@ -535,12 +1184,36 @@ func (p *Protocol) ProcessMessage(ctx context.Context, msg *netlink.Message, ms
 		switch hdr.Type {
 		case linux.RTM_GETLINK:
 			return p.getLink(ctx, msg, ms)
+		case linux.RTM_NEWLINK, linux.RTM_SETLINK:
+			return p.setLink(ctx, msg, ms)
+		case linux.RTM_DELLINK:
+			return p.delLink(ctx, msg, ms)
 		case linux.RTM_GETROUTE:
 			return p.dumpRoutes(ctx, msg, ms)
+		case linux.RTM_NEWROUTE:
+			return p.newRoute(ctx, msg, ms)
+		case linux.RTM_DELROUTE:
+			return p.delRoute(ctx, msg, ms)
 		case linux.RTM_NEWADDR:
 			return p.newAddr(ctx, msg, ms)
 		case linux.RTM_DELADDR:
 			return p.delAddr(ctx, msg, ms)
+		case linux.RTM_NEWNEIGH:
+			return p.newNeighbor(ctx, msg, ms)
+		case linux.RTM_DELNEIGH:
+			return p.delNeighbor(ctx, msg, ms)
+		case linux.RTM_NEWRULE:
+			return p.newRule(ctx, msg, ms)
+		case linux.RTM_DELRULE:
+			return p.delRule(ctx, msg, ms)
+		case linux.RTM_NEWQDISC:
+			return p.newQdisc(ctx, msg, ms)
+		case linux.RTM_DELQDISC:
+			return p.delQdisc(ctx, msg, ms)
+		case linux.RTM_NEWADDRLABEL:
+			return p.newAddrLabel(ctx, msg, ms)
+		case linux.RTM_DELADDRLABEL:
+			return p.delAddrLabel(ctx, msg, ms)
 		default:
 			return syserr.ErrNotSupported
 		}
@@ -0,0 +1,541 @@
+// Copyright 2021 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package route
+
+import (
+	"math"
+	"time"
+
+	"gvisor.dev/gvisor/pkg/abi/linux"
+	"gvisor.dev/gvisor/pkg/binary"
+	"gvisor.dev/gvisor/pkg/context"
+	"gvisor.dev/gvisor/pkg/sentry/inet"
+	"gvisor.dev/gvisor/pkg/sentry/socket/netlink"
+	"gvisor.dev/gvisor/pkg/syserr"
+	"gvisor.dev/gvisor/pkg/usermem"
+)
+
+// ethernetHeaderSize is the size of the link header added to the packets of
+// Ethernet interfaces, which the default quantum of qdiscs accounts for.
+const ethernetHeaderSize = 14
+
+// Default qdisc parameters, from Linux.
+const (
+	defaultNetemLimit      = 1000
+	defaultFQLimit         = 10000
+	defaultFQFlowLimit     = 100
+	defaultFQCoDelLimit    = 10240
+	defaultFQCoDelFlows    = 1024
+	defaultFQCoDelTarget   = 5 * time.Millisecond
+	defaultFQCoDelInterval = 100 * time.Millisecond
+)
+
+// ticksToDuration converts packet scheduler ticks to a duration.
+func ticksToDuration(ticks uint32) time.Duration {
+	return time.Duration(uint64(ticks) << linux.PSCHED_SHIFT)
+}
+
+// durationToTicks converts a duration to packet scheduler ticks.
+func durationToTicks(d time.Duration) uint32 {
+	ticks := uint64(d) >> linux.PSCHED_SHIFT
+	if ticks > math.MaxUint32 {
+		return math.MaxUint32
+	}
+	return uint32(ticks)
+}
+
+// saturateUint32 converts v to an uint32, saturating it to math.MaxUint32.
+func saturateUint32(v uint64) uint32 {
+	if v > math.MaxUint32 {
+		return math.MaxUint32
+	}
+	return uint32(v)
+}
+
+// newQdisc returns a qdisc of kind for iface with default parameters, or false
+// if the kind is not supported.
+func newQdisc(kind string, iface inet.Interface) (inet.QueueingDiscipline, bool) {
+	// The MTU of the packets, including their link header.
+	mtu := iface.MTU
+	if iface.DeviceType == linux.ARPHRD_ETHER {
+		mtu += ethernetHeaderSize
+	}
+
+	switch kind {
+	case "tbf":
+		return inet.QueueingDiscipline{Kind: kind}, true
+	case "netem":
+		return inet.QueueingDiscipline{
+			Kind:  kind,
+			Limit: defaultNetemLimit,
+		}, true
+	case "fq":
+		return inet.QueueingDiscipline{
+			Kind:      kind,
+			Limit:     defaultFQLimit,
+			FlowLimit: defaultFQFlowLimit,
+			Quantum:   2 * mtu,
+		}, true
+	case "fq_codel":
+		return inet.QueueingDiscipline{
+			Kind:     kind,
+			Limit:    defaultFQCoDelLimit,
+			Flows:    defaultFQCoDelFlows,
+			Quantum:  mtu,
+			Target:   defaultFQCoDelTarget,
+			Interval: defaultFQCoDelInterval,
+		}, true
+	default:
+		return inet.QueueingDiscipline{}, false
+	}
+}
+
+// parseQdiscOptions parses the TCA_OPTIONS attribute of a qdisc into qdisc.
+// Parameters missing from options are left unchanged.
+func parseQdiscOptions(qdisc *inet.QueueingDiscipline, options []byte) *syserr.Error {
+	switch qdisc.Kind {
+	case "tbf":
+		return parseTBFOptions(qdisc, options)
+	case "netem":
+		return parseNetemOptions(qdisc, options)
+	case "fq":
+		return parseFQOptions(qdisc, options)
+	case "fq_codel":
+		return parseFQCoDelOptions(qdisc, options)
+	default:
+		panic("unknown qdisc kind " + qdisc.Kind)
+	}
+}
+
+// parseTBFOptions parses the options of a tbf qdisc.
+func parseTBFOptions(qdisc *inet.QueueingDiscipline, options []byte) *syserr.Error {
+	var (
+		qopt      linux.TCTBFQopt
+		haveQopt  bool
+		rate64    uint64
+		burst     uint32
+		haveBurst bool
+	)
+	for attrs := netlink.AttrsView(options); !attrs.Empty(); {
+		ahdr, value, rest, ok := attrs.ParseFirst()
+		if !ok {
+			return syserr.ErrInvalidArgument
+		}
+		attrs = rest
+
+		switch ahdr.Type {
+		case linux.TCA_TBF_PARMS:
+			if len(value) < linux.SizeOfTCTBFQopt {
+				return syserr.ErrInvalidArgument
+			}
+			binary.Unmarshal(value[:linux.SizeOfTCTBFQopt], usermem.ByteOrder, &qopt)
+			haveQopt = true
+		case linux.TCA_TBF_RATE64:
+			if len(value) < 8 {
+				return syserr.ErrInvalidArgument
+			}
+			rate64 = usermem.ByteOrder.Uint64(value)
+		case linux.TCA_TBF_BURST:
+			if burst, ok = parseUint32Attr(value); !ok {
+				return syserr.ErrInvalidArgument
+			}
+			haveBurst = true
+		case linux.TCA_TBF_RTAB, linux.TCA_TBF_PTAB:
+			// Rate tables are only used by Linux to compute transmission
+			// times.
+		default:
+			return syserr.ErrNotSupported
+		}
+	}
+
+	if !haveQopt {
+		return syserr.ErrInvalidArgument
+	}
+	// Peak rates are not supported.
+	if qopt.PeakRate.Rate != 0 {
+		return syserr.ErrNotSupported
+	}
+
+	qdisc.Rate = uint64(qopt.Rate.Rate)
+	if rate64 != 0 {
+		qdisc.Rate = rate64
+	}
+	qdisc.Limit = qopt.Limit
+	if haveBurst {
+		qdisc.Burst = burst
+	} else {
+		// The size of the bucket is the time needed to send it at the rate of
+		// the filter.
+		qdisc.Burst = saturateUint32(uint64(float64(ticksToDuration(qopt.Buffer)) * float64(qdisc.Rate) / float64(time.Second)))
+	}
+	return nil
+}
+
+// parseNetemOptions parses the options of a netem qdisc.
+//
+// Unlike other qdiscs, the options start with a struct followed by attributes.
+func parseNetemOptions(qdisc *inet.QueueingDiscipline, options []byte) *syserr.Error {
+	if len(options) < linux.SizeOfTCNetemQopt {
+		return syserr.ErrInvalidArgument
+	}
+	var qopt linux.TCNetemQopt
+	binary.Unmarshal(options[:linux.SizeOfTCNetemQopt], usermem.ByteOrder, &qopt)
+
+	// Reordering is not supported.
+	if qopt.Gap != 0 {
+		return syserr.ErrNotSupported
+	}
+	qdisc.Latency = ticksToDuration(qopt.Latency)
+	qdisc.Jitter = ticksToDuration(qopt.Jitter)
+	qdisc.Limit = qopt.Limit
+	qdisc.Loss = qopt.Loss
+	qdisc.Duplicate = qopt.Duplicate
+
+	for attrs := netlink.AttrsView(options[linux.SizeOfTCNetemQopt:]); !attrs.Empty(); {
+		ahdr, value, rest, ok := attrs.ParseFirst()
+		if !ok {
+			return syserr.ErrInvalidArgument
+		}
+		attrs = rest
+
+		switch ahdr.Type {
+		case linux.TCA_NETEM_LATENCY64, linux.TCA_NETEM_JITTER64:
+			if len(value) < 8 {
+				return syserr.ErrInvalidArgument
+			}
+			d := time.Duration(usermem.ByteOrder.Uint64(value))
+			if d < 0 {
+				return syserr.ErrInvalidArgument
+			}
+			if ahdr.Type == linux.TCA_NETEM_LATENCY64 {
+				qdisc.Latency = d
+			} else {
+				qdisc.Jitter = d
+			}
+		case linux.TCA_NETEM_CORR:
+			// Correlations are not supported; only the unset value is
+			// accepted.
+			for _, b := range value {
+				if b != 0 {
+					return syserr.ErrNotSupported
+				}
+			}
+		default:
+			return syserr.ErrNotSupported
+		}
+	}
+	return nil
+}
+
+// parseFQOptions parses the options of a fq qdisc.
+func parseFQOptions(qdisc *inet.QueueingDiscipline, options []byte) *syserr.Error {
+	for attrs := netlink.AttrsView(options); !attrs.Empty(); {
+		ahdr, value, rest, ok := attrs.ParseFirst()
+		if !ok {
+			return syserr.ErrInvalidArgument
+		}
+		attrs = rest
+
+		switch ahdr.Type {
+		case linux.TCA_FQ_PLIMIT, linux.TCA_FQ_FLOW_PLIMIT, linux.TCA_FQ_QUANTUM, linux.TCA_FQ_FLOW_MAX_RATE:
+			v, ok := parseUint32Attr(value)
+			if !ok {
+				return syserr.ErrInvalidArgument
+			}
+			switch ahdr.Type {
+			case linux.TCA_FQ_PLIMIT:
+				qdisc.Limit = v
+			case linux.TCA_FQ_FLOW_PLIMIT:
+				qdisc.FlowLimit = v
+			case linux.TCA_FQ_QUANTUM:
+				if v == 0 {
+					return syserr.ErrInvalidArgument
+				}
+				qdisc.Quantum = v
+			case linux.TCA_FQ_FLOW_MAX_RATE:
+				// The maximum value means that flows are not paced.
+				qdisc.Rate = uint64(v)
+				if v == math.MaxUint32 {
+					qdisc.Rate = 0
+				}
+			}
+		case linux.TCA_FQ_INITIAL_QUANTUM, linux.TCA_FQ_RATE_ENABLE, linux.TCA_FQ_FLOW_DEFAULT_RATE,
+			linux.TCA_FQ_BUCKETS_LOG, linux.TCA_FQ_FLOW_REFILL_DELAY, linux.TCA_FQ_ORPHAN_MASK,
+			linux.TCA_FQ_LOW_RATE_THRESHOLD, linux.TCA_FQ_TIMER_SLACK, linux.TCA_FQ_HORIZON,
+			linux.TCA_FQ_HORIZON_DROP:
+			// These only tune the implementation of Linux.
+		default:
+			return syserr.ErrNotSupported
+		}
+	}
+	return nil
+}
+
+// parseFQCoDelOptions parses the options of a fq_codel qdisc.
+func parseFQCoDelOptions(qdisc *inet.QueueingDiscipline, options []byte) *syserr.Error {
+	for attrs := netlink.AttrsView(options); !attrs.Empty(); {
+		ahdr, value, rest, ok := attrs.ParseFirst()
+		if !ok {
+			return syserr.ErrInvalidArgument
+		}
+		attrs = rest
+
+		switch ahdr.Type {
+		case linux.TCA_FQ_CODEL_TARGET, linux.TCA_FQ_CODEL_LIMIT, linux.TCA_FQ_CODEL_INTERVAL,
+			linux.TCA_FQ_CODEL_FLOWS, linux.TCA_FQ_CODEL_QUANTUM:
+			v, ok := parseUint32Attr(value)
+			if !ok {
+				return syserr.ErrInvalidArgument
+			}
+			switch ahdr.Type {
+			case linux.TCA_FQ_CODEL_TARGET:
+				qdisc.Target = time.Duration(v) * time.Microsecond
+			case linux.TCA_FQ_CODEL_LIMIT:
+				qdisc.Limit = v
+			case linux.TCA_FQ_CODEL_INTERVAL:
+				qdisc.Interval = time.Duration(v) * time.Microsecond
+			case linux.TCA_FQ_CODEL_FLOWS:
+				qdisc.Flows = v
+			case linux.TCA_FQ_CODEL_QUANTUM:
+				qdisc.Quantum = v
+			}
+		case linux.TCA_FQ_CODEL_ECN, linux.TCA_FQ_CODEL_DROP_BATCH_SIZE, linux.TCA_FQ_CODEL_MEMORY_LIMIT:
+			// Packets are always dropped rather than marked, and the queues
+			// are only limited in packets.
+		default:
+			return syserr.ErrNotSupported
+		}
+	}
+	return nil
+}
+
+// putQdiscOptions adds the TCA_OPTIONS attribute of qdisc to m.
+func putQdiscOptions(m *netlink.Message, qdisc inet.QueueingDiscipline) {
+	var opts netlink.NestedAttrs
+	switch qdisc.Kind {
+	case "tbf":
+		opts.PutAttr(linux.TCA_TBF_PARMS, linux.TCTBFQopt{
+			Rate: linux.TCRateSpec{
+				LinkLayer: linux.TC_LINKLAYER_ETHERNET,
+				Rate:      saturateUint32(qdisc.Rate),
+			},
+			Limit:  qdisc.Limit,
+			Buffer: durationToTicks(time.Duration(uint64(qdisc.Burst) * uint64(time.Second) / qdisc.Rate)),
+		})
+		if qdisc.Rate > math.MaxUint32 {
+			opts.PutAttr(linux.TCA_TBF_RATE64, qdisc.Rate)
+		}
+		opts.PutAttr(linux.TCA_TBF_BURST, qdisc.Burst)
+	case "netem":
+		qopt := linux.TCNetemQopt{
+			Latency:   durationToTicks(qdisc.Latency),
+			Limit:     qdisc.Limit,
+			Loss:      qdisc.Loss,
+			Duplicate: qdisc.Duplicate,
+			Jitter:    durationToTicks(qdisc.Jitter),
+		}
+		opts.PutAttr(linux.TCA_NETEM_LATENCY64, int64(qdisc.Latency))
+		opts.PutAttr(linux.TCA_NETEM_JITTER64, int64(qdisc.Jitter))
+		m.PutAttr(linux.TCA_OPTIONS, append(binary.Marshal(nil, usermem.ByteOrder, qopt), opts.Bytes()...))
+		return
+	case "fq":
+		maxRate := uint32(math.MaxUint32)
+		if qdisc.Rate != 0 {
+			maxRate = saturateUint32(qdisc.Rate)
+		}
+		opts.PutAttr(linux.TCA_FQ_PLIMIT, qdisc.Limit)
+		opts.PutAttr(linux.TCA_FQ_FLOW_PLIMIT, qdisc.FlowLimit)
+		opts.PutAttr(linux.TCA_FQ_QUANTUM, qdisc.Quantum)
+		opts.PutAttr(linux.TCA_FQ_FLOW_MAX_RATE, maxRate)
+	case "fq_codel":
+		opts.PutAttr(linux.TCA_FQ_CODEL_TARGET, uint32(qdisc.Target/time.Microsecond))
+		opts.PutAttr(linux.TCA_FQ_CODEL_LIMIT, qdisc.Limit)
+		opts.PutAttr(linux.TCA_FQ_CODEL_INTERVAL, uint32(qdisc.Interval/time.Microsecond))
+		opts.PutAttr(linux.TCA_FQ_CODEL_ECN, uint32(0))
+		opts.PutAttr(linux.TCA_FQ_CODEL_QUANTUM, qdisc.Quantum)
+		opts.PutAttr(linux.TCA_FQ_CODEL_FLOWS, qdisc.Flows)
+	}
+	m.PutAttr(linux.TCA_OPTIONS, opts.Bytes())
+}
+
+// dumpQdiscs handles RTM_GETQDISC dump requests.
+//
+// Interfaces without a configured qdisc report a noqueue root qdisc, since
+// netstack writes their packets directly.
+func (p *Protocol) dumpQdiscs(ctx context.Context, msg *netlink.Message, ms *netlink.MessageSet) *syserr.Error {
+	// We always send back an NLMSG_DONE.
+	ms.Multi = true
+
+	stack := inet.StackFromContext(ctx)
+	if stack == nil {
+		// No network devices.
+		return nil
+	}
+
+	qdiscs := stack.QueueingDisciplines()
+	for idx := range stack.Interfaces() {
+		m := ms.AddMessage(linux.NetlinkMessageHeader{
+			Type: linux.RTM_NEWQDISC,
+		})
+
+		m.Put(linux.TCMessage{
+			Family:  linux.AF_UNSPEC,
+			Ifindex: idx,
+			Parent:  linux.TC_H_ROOT,
+			Info:    2, // The reference count.
+		})
+
+		qdisc, ok := qdiscs[idx]
+		if !ok {
+			m.PutAttrString(linux.TCA_KIND, "noqueue")
+			continue
+		}
+		m.PutAttrString(linux.TCA_KIND, qdisc.Kind)
+		putQdiscOptions(m, qdisc)
+	}
+
+	return nil
+}
+
+// newQdisc handles RTM_NEWQDISC requests.
+//
+// Only root qdiscs are supported.
+func (p *Protocol) newQdisc(ctx context.Context, msg *netlink.Message, ms *netlink.MessageSet) *syserr.Error {
+	stack := inet.StackFromContext(ctx)
+	if stack == nil {
+		// No network stack.
+		return syserr.ErrProtocolNotSupported
+	}
+
+	var tcm linux.TCMessage
+	attrs, ok := msg.GetData(&tcm)
+	if !ok {
+		return syserr.ErrInvalidArgument
+	}
+	idx, iface, err := findInterface(stack, tcm.Ifindex, nil)
+	if err != nil {
+		return err
+	}
+	if tcm.Parent != linux.TC_H_ROOT {
+		return syserr.ErrNotSupported
+	}
+
+	var (
+		kind    string
+		options []byte
+	)
+	for !attrs.Empty() {
+		ahdr, value, rest, ok := attrs.ParseFirst()
+		if !ok {
+			return syserr.ErrInvalidArgument
+		}
+		attrs = rest
+
+		switch ahdr.Type {
+		case linux.TCA_KIND:
+			if len(value) < 1 {
+				return syserr.ErrInvalidArgument
+			}
+			kind = string(value[:len(value)-1])
+		case linux.TCA_OPTIONS:
+			options = value
+		default:
+			return syserr.ErrNotSupported
+		}
+	}
+
+	flags := msg.Header().Flags
+	old, exists := stack.QueueingDisciplines()[idx]
+	switch {
+	case exists && flags&linux.NLM_F_EXCL != 0:
+		return syserr.ErrExists
+	case !exists && flags&linux.NLM_F_CREATE == 0:
+		return syserr.ErrNoFileOrDir
+	case exists && flags&linux.NLM_F_REPLACE == 0 && kind != "" && kind != old.Kind:
+		// The kind of a qdisc cannot be changed without replacing it.
+		return syserr.ErrInvalidArgument
+	}
+
+	var qdisc inet.QueueingDiscipline
+	switch {
+	case exists && (kind == "" || kind == old.Kind):
+		// Change the parameters of the qdisc.
+		qdisc = old
+	case kind == "":
+		return syserr.ErrInvalidArgument
+	default:
+		if qdisc, ok = newQdisc(kind, iface); !ok {
+			// As in Linux, unknown kinds are not found.
+			return syserr.ErrNoFileOrDir
+		}
+	}
+	if err := parseQdiscOptions(&qdisc, options); err != nil {
+		return err
+	}
+
+	if err := stack.SetQueueingDiscipline(idx, &qdisc); err != nil {
+		return syserr.FromError(err)
+	}
+	return nil
+}
+
+// delQdisc handles RTM_DELQDISC requests.
+func (p *Protocol) delQdisc(ctx context.Context, msg *netlink.Message, ms *netlink.MessageSet) *syserr.Error {
+	stack := inet.StackFromContext(ctx)
+	if stack == nil {
+		// No network stack.
+		return syserr.ErrProtocolNotSupported
+	}
+
+	var tcm linux.TCMessage
+	attrs, ok := msg.GetData(&tcm)
+	if !ok {
+		return syserr.ErrInvalidArgument
+	}
+	idx, _, err := findInterface(stack, tcm.Ifindex, nil)
+	if err != nil {
+		return err
+	}
+	if tcm.Parent != linux.TC_H_ROOT {
+		return syserr.ErrNotSupported
+	}
+
+	qdisc, exists := stack.QueueingDisciplines()[idx]
+	kind := "noqueue"
+	if exists {
+		kind = qdisc.Kind
+	}
+	for !attrs.Empty() {
+		ahdr, value, rest, ok := attrs.ParseFirst()
+		if !ok {
+			return syserr.ErrInvalidArgument
+		}
+		attrs = rest
+
+		// As in Linux, the kind must match the deleted qdisc if given.
+		if ahdr.Type == linux.TCA_KIND && (len(value) < 1 || string(value[:len(value)-1]) != kind) {
+			return syserr.ErrInvalidArgument
+		}
+	}
+
+	// The default qdisc cannot be deleted.
+	if !exists {
+		return syserr.ErrNoFileOrDir
+	}
+	if err := stack.SetQueueingDiscipline(idx, nil); err != nil {
+		return syserr.FromError(err)
+	}
+	return nil
+}
@@ -0,0 +1,299 @@
+// Copyright 2021 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package route
+
+import (
+	"gvisor.dev/gvisor/pkg/abi/linux"
+	"gvisor.dev/gvisor/pkg/binary"
+	"gvisor.dev/gvisor/pkg/context"
+	"gvisor.dev/gvisor/pkg/sentry/inet"
+	"gvisor.dev/gvisor/pkg/sentry/socket/netlink"
+	"gvisor.dev/gvisor/pkg/syserr"
+)
+
+const (
+	// maxVNI is the largest 24-bit virtual network identifier.
+	maxVNI = 1<<24 - 1
+
+	// defaultVXLANPort is the UDP port used by Linux for VXLAN tunnels
+	// created without IFLA_VXLAN_PORT, which predates the IANA assigned
+	// port.
+	defaultVXLANPort = 8472
+
+	// defaultGENEVEPort is the IANA assigned GENEVE UDP port.
+	defaultGENEVEPort = 6081
+)
+
+// parseLinkInfo parses the IFLA_LINKINFO attribute of a RTM_NEWLINK request
+// creating a tunnel interface.
+func parseLinkInfo(linkInfo []byte) (*inet.Tunnel, *syserr.Error) {
+	var (
+		kind string
+		data []byte
+	)
+	for attrs := netlink.AttrsView(linkInfo); !attrs.Empty(); {
+		ahdr, value, rest, ok := attrs.ParseFirst()
+		if !ok {
+			return nil, syserr.ErrInvalidArgument
+		}
+		attrs = rest
+
+		switch ahdr.Type & linux.NLA_TYPE_MASK {
+		case linux.IFLA_INFO_KIND:
+			// The kind may or may not be NUL-terminated.
+			for len(value) > 0 && value[len(value)-1] == 0 {
+				value = value[:len(value)-1]
+			}
+			kind = string(value)
+		case linux.IFLA_INFO_DATA:
+			data = value
+		}
+	}
+
+	switch kind {
+	case "vxlan":
+		return parseVXLANData(data)
+	case "geneve":
+		return parseGENEVEData(data)
+	default:
+		return nil, syserr.ErrNotSupported
+	}
+}
+
+// parseVXLANData parses the IFLA_INFO_DATA attribute of a VXLAN interface.
+func parseVXLANData(data []byte) (*inet.Tunnel, *syserr.Error) {
+	t := inet.Tunnel{
+		Kind:     "vxlan",
+		Port:     defaultVXLANPort,
+		Learning: true,
+	}
+	hasVNI := false
+	for attrs := netlink.AttrsView(data); !attrs.Empty(); {
+		ahdr, value, rest, ok := attrs.ParseFirst()
+		if !ok {
+			return nil, syserr.ErrInvalidArgument
+		}
+		attrs = rest
+
+		switch ahdr.Type & linux.NLA_TYPE_MASK {
+		case linux.IFLA_VXLAN_ID:
+			v, ok := parseUint32Attr(value)
+			if !ok || v > maxVNI {
+				return nil, syserr.ErrInvalidArgument
+			}
+			t.VNI = v
+			hasVNI = true
+		case linux.IFLA_VXLAN_GROUP, linux.IFLA_VXLAN_LOCAL:
+			if len(value) != 4 {
+				return nil, syserr.ErrInvalidArgument
+			}
+			if ahdr.Type&linux.NLA_TYPE_MASK == linux.IFLA_VXLAN_GROUP {
+				t.Remote = value
+			} else {
+				t.Local = value
+			}
+		case linux.IFLA_VXLAN_GROUP6, linux.IFLA_VXLAN_LOCAL6:
+			if len(value) != 16 {
+				return nil, syserr.ErrInvalidArgument
+			}
+			if ahdr.Type&linux.NLA_TYPE_MASK == linux.IFLA_VXLAN_GROUP6 {
+				t.Remote = value
+			} else {
+				t.Local = value
+			}
+		case linux.IFLA_VXLAN_LINK:
+			v, ok := parseUint32Attr(value)
+			if !ok {
+				return nil, syserr.ErrInvalidArgument
+			}
+			t.Link = int32(v)
+		case linux.IFLA_VXLAN_LEARNING:
+			if len(value) < 1 {
+				return nil, syserr.ErrInvalidArgument
+			}
+			t.Learning = value[0] != 0
+		case linux.IFLA_VXLAN_PORT:
+			if len(value) < 2 {
+				return nil, syserr.ErrInvalidArgument
+			}
+			t.Port = binary.BigEndian.Uint16(value)
+		case linux.IFLA_VXLAN_TTL, linux.IFLA_VXLAN_TOS, linux.IFLA_VXLAN_AGEING, linux.IFLA_VXLAN_LIMIT:
+			// The defaults of the tunnel are used instead.
+		default:
+			return nil, syserr.ErrNotSupported
+		}
+	}
+	if !hasVNI {
+		return nil, syserr.ErrInvalidArgument
+	}
+	return &t, nil
+}
+
+// parseGENEVEData parses the IFLA_INFO_DATA attribute of a GENEVE interface.
+func parseGENEVEData(data []byte) (*inet.Tunnel, *syserr.Error) {
+	t := inet.Tunnel{
+		Kind: "geneve",
+		Port: defaultGENEVEPort,
+	}
+	for attrs := netlink.AttrsView(data); !attrs.Empty(); {
+		ahdr, value, rest, ok := attrs.ParseFirst()
+		if !ok {
+			return nil, syserr.ErrInvalidArgument
+		}
+		attrs = rest
+
+		switch ahdr.Type & linux.NLA_TYPE_MASK {
+		case linux.IFLA_GENEVE_ID:
+			v, ok := parseUint32Attr(value)
+			if !ok || v > maxVNI {
+				return nil, syserr.ErrInvalidArgument
+			}
+			t.VNI = v
+		case linux.IFLA_GENEVE_REMOTE:
+			if len(value) != 4 {
+				return nil, syserr.ErrInvalidArgument
+			}
+			t.Remote = value
+		case linux.IFLA_GENEVE_REMOTE6:
+			if len(value) != 16 {
+				return nil, syserr.ErrInvalidArgument
+			}
+			t.Remote = value
+		case linux.IFLA_GENEVE_PORT:
+			if len(value) < 2 {
+				return nil, syserr.ErrInvalidArgument
+			}
+			t.Port = binary.BigEndian.Uint16(value)
+		case linux.IFLA_GENEVE_TTL, linux.IFLA_GENEVE_TOS:
+			// The defaults of the tunnel are used instead.
+		default:
+			return nil, syserr.ErrNotSupported
+		}
+	}
+	// Unlike VXLAN, GENEVE has no learning so the remote is mandatory.
+	if t.Remote == nil {
+		return nil, syserr.ErrInvalidArgument
+	}
+	return &t, nil
+}
+
+// putLinkInfo adds the IFLA_LINKINFO attribute of the tunnel interface t to
+// m.
+func putLinkInfo(m *netlink.Message, t *inet.Tunnel) {
+	port := make([]byte, 2)
+	binary.BigEndian.PutUint16(port, t.Port)
+
+	var data netlink.NestedAttrs
+	switch t.Kind {
+	case "vxlan":
+		data.PutAttr(linux.IFLA_VXLAN_ID, t.VNI)
+		if len(t.Remote) == 16 {
+			data.PutAttr(linux.IFLA_VXLAN_GROUP6, t.Remote)
+		} else if len(t.Remote) != 0 {
+			data.PutAttr(linux.IFLA_VXLAN_GROUP, t.Remote)
+		}
+		if len(t.Local) == 16 {
+			data.PutAttr(linux.IFLA_VXLAN_LOCAL6, t.Local)
+		} else if len(t.Local) != 0 {
+			data.PutAttr(linux.IFLA_VXLAN_LOCAL, t.Local)
+		}
+		if t.Link != 0 {
+			data.PutAttr(linux.IFLA_VXLAN_LINK, uint32(t.Link))
+		}
+		var learning uint8
+		if t.Learning {
+			learning = 1
+		}
+		data.PutAttr(linux.IFLA_VXLAN_LEARNING, learning)
+		data.PutAttr(linux.IFLA_VXLAN_PORT, port)
+	case "geneve":
+		data.PutAttr(linux.IFLA_GENEVE_ID, t.VNI)
+		if len(t.Remote) == 16 {
+			data.PutAttr(linux.IFLA_GENEVE_REMOTE6, t.Remote)
+		} else if len(t.Remote) != 0 {
+			data.PutAttr(linux.IFLA_GENEVE_REMOTE, t.Remote)
+		}
+		data.PutAttr(linux.IFLA_GENEVE_PORT, port)
+	}
+
+	var info netlink.NestedAttrs
+	info.PutAttr(linux.IFLA_INFO_KIND, append([]byte(t.Kind), 0))
+	info.PutAttr(linux.IFLA_INFO_DATA, data.Bytes())
+	m.PutAttr(linux.IFLA_LINKINFO, info.Bytes())
+}
+
+// newLink creates the tunnel interface requested by a RTM_NEWLINK request with
+// IFLA_LINKINFO.
+func newLink(stack inet.Stack, name, hwAddr []byte, mtu uint32, linkInfo []byte) *syserr.Error {
+	t, err := parseLinkInfo(linkInfo)
+	if err != nil {
+		return err
+	}
+	if t.Link != 0 {
+		if _, ok := stack.Interfaces()[t.Link]; !ok {
+			return syserr.ErrNoDevice
+		}
+	}
+	iface := inet.Interface{
+		Name:   string(name),
+		Addr:   hwAddr,
+		MTU:    mtu,
+		Tunnel: t,
+	}
+	if _, err := stack.AddInterface(iface); err != nil {
+		return syserr.FromError(err)
+	}
+	return nil
+}
+
+// delLink handles RTM_DELLINK requests.
+func (p *Protocol) delLink(ctx context.Context, msg *netlink.Message, ms *netlink.MessageSet) *syserr.Error {
+	stack := inet.StackFromContext(ctx)
+	if stack == nil {
+		// No network stack.
+		return syserr.ErrProtocolNotSupported
+	}
+
+	var ifi linux.InterfaceInfoMessage
+	attrs, ok := msg.GetData(&ifi)
+	if !ok {
+		return syserr.ErrInvalidArgument
+	}
+
+	var byName []byte
+	for !attrs.Empty() {
+		ahdr, value, rest, ok := attrs.ParseFirst()
+		if !ok {
+			return syserr.ErrInvalidArgument
+		}
+		attrs = rest
+
+		if ahdr.Type == linux.IFLA_IFNAME {
+			if len(value) < 1 {
+				return syserr.ErrInvalidArgument
+			}
+			byName = value[:len(value)-1]
+		}
+	}
+
+	idx, _, err := findInterface(stack, ifi.Index, byName)
+	if err != nil {
+		return err
+	}
+	if err := stack.RemoveInterface(idx); err != nil {
+		return syserr.FromError(err)
+	}
+	return nil
+}
@@ -149,7 +149,7 @@ func NewSocket(t *kernel.Task, skType linux.SockType, protocol Protocol) (*Socke
 		return nil, err
 	}
 
-	return &Socket{
+	s := &Socket{
 		socketOpsCommon: socketOpsCommon{
 			ports:          t.Kernel().NetlinkPorts(),
 			protocol:       protocol,
@@ -158,11 +158,18 @@ func NewSocket(t *kernel.Task, skType linux.SockType, protocol Protocol) (*Socke
 			connection:     connection,
 			sendBufferSize: defaultSendBufferSize,
 		},
-	}, nil
+	}
+	if sp, ok := protocol.(SenderProtocol); ok {
+		sp.SetSender(&s.socketOpsCommon)
+	}
+	return s, nil
 }
 
 // Release implements fs.FileOperations.Release.
 func (s *socketOpsCommon) Release(ctx context.Context) {
+	if sp, ok := s.protocol.(SenderProtocol); ok {
+		sp.SetSender(nil)
+	}
 	s.connection.Release(ctx)
 	s.ep.Close(ctx)
 
@@ -653,6 +660,11 @@ func (s *socketOpsCommon) sendResponse(ctx context.Context, ms *MessageSet) *sys
 	return nil
 }
 
+// Send implements Sender.Send.
+func (s *socketOpsCommon) Send(ctx context.Context, ms *MessageSet) *syserr.Error {
+	return s.sendResponse(ctx, ms)
+}
+
 func dumpErrorMesage(hdr linux.NetlinkMessageHeader, ms *MessageSet, err *syserr.Error) {
 	m := ms.AddMessage(linux.NetlinkMessageHeader{
 		Type: linux.NLMSG_ERROR,
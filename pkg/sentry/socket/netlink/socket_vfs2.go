@@ -80,6 +80,9 @@ func NewVFS2(t *kernel.Task, skType linux.SockType, protocol Protocol) (*SocketV
 		},
 	}
 	fd.LockFD.Init(&vfs.FileLocks{})
+	if sp, ok := protocol.(SenderProtocol); ok {
+		sp.SetSender(&fd.socketOpsCommon)
+	}
 	return fd, nil
 }
 
@@ -31,6 +31,7 @@ import (
 	"io/ioutil"
 	"math"
 	"reflect"
+	"runtime"
 	"syscall"
 	"time"
 
@@ -49,6 +50,7 @@ import (
 	"gvisor.dev/gvisor/pkg/sentry/kernel"
 	ktime "gvisor.dev/gvisor/pkg/sentry/kernel/time"
 	"gvisor.dev/gvisor/pkg/sentry/socket"
+	"gvisor.dev/gvisor/pkg/sentry/socket/control"
 	"gvisor.dev/gvisor/pkg/sentry/socket/netfilter"
 	"gvisor.dev/gvisor/pkg/sentry/unimpl"
 	"gvisor.dev/gvisor/pkg/sync"
@@ -156,6 +158,7 @@ var Metrics = tcpip.Stats{
 				MembershipQuery:    mustCreateMetric("/netstack/igmp/packets_sent/membership_query", "Total number of IGMP Membership Query messages sent by netstack."),
 				V1MembershipReport: mustCreateMetric("/netstack/igmp/packets_sent/v1_membership_report", "Total number of IGMPv1 Membership Report messages sent by netstack."),
 				V2MembershipReport: mustCreateMetric("/netstack/igmp/packets_sent/v2_membership_report", "Total number of IGMPv2 Membership Report messages sent by netstack."),
+				V3MembershipReport: mustCreateMetric("/netstack/igmp/packets_sent/v3_membership_report", "Total number of IGMPv3 Membership Report messages sent by netstack."),
 				LeaveGroup:         mustCreateMetric("/netstack/igmp/packets_sent/leave_group", "Total number of IGMP Leave Group messages sent by netstack."),
 			},
 			Dropped: mustCreateMetric("/netstack/igmp/packets_sent/dropped", "Total number of IGMP packets dropped by netstack due to link layer errors."),
@@ -165,6 +168,7 @@ var Metrics = tcpip.Stats{
 				MembershipQuery:    mustCreateMetric("/netstack/igmp/packets_received/membership_query", "Total number of IGMP Membership Query messages received by netstack."),
 				V1MembershipReport: mustCreateMetric("/netstack/igmp/packets_received/v1_membership_report", "Total number of IGMPv1 Membership Report messages received by netstack."),
 				V2MembershipReport: mustCreateMetric("/netstack/igmp/packets_received/v2_membership_report", "Total number of IGMPv2 Membership Report messages received by netstack."),
+				V3MembershipReport: mustCreateMetric("/netstack/igmp/packets_received/v3_membership_report", "Total number of IGMPv3 Membership Report messages received by netstack."),
 				LeaveGroup:         mustCreateMetric("/netstack/igmp/packets_received/leave_group", "Total number of IGMP Leave Group messages received by netstack."),
 			},
 			Invalid:        mustCreateMetric("/netstack/igmp/packets_received/invalid", "Total number of IGMP packets received by netstack that could not be parsed."),
@@ -210,9 +214,12 @@ var Metrics = tcpip.Stats{
 		EstablishedTimedout:                mustCreateMetric("/netstack/tcp/established_timedout", "Number of times  an established connection was reset because of keep-alive time out."),
 		ListenOverflowSynDrop:              mustCreateMetric("/netstack/tcp/listen_overflow_syn_drop", "Number of times the listen queue overflowed and a SYN was dropped."),
 		ListenOverflowAckDrop:              mustCreateMetric("/netstack/tcp/listen_overflow_ack_drop", "Number of times the listen queue overflowed and the final ACK in the handshake was dropped."),
+		ListenOverflowAckReset:             mustCreateMetric("/netstack/tcp/listen_overflow_ack_reset", "Number of times the listen queue overflowed and the final ACK in the handshake was answered with a RST."),
 		ListenOverflowSynCookieSent:        mustCreateMetric("/netstack/tcp/listen_overflow_syn_cookie_sent", "Number of times a SYN cookie was sent."),
 		ListenOverflowSynCookieRcvd:        mustCreateMetric("/netstack/tcp/listen_overflow_syn_cookie_rcvd", "Number of times a SYN cookie was received."),
 		ListenOverflowInvalidSynCookieRcvd: mustCreateMetric("/netstack/tcp/listen_overflow_invalid_syn_cookie_rcvd", "Number of times an invalid SYN cookie was received."),
+		MigrateReqSuccess:                  mustCreateMetric("/netstack/tcp/migrate_req_success", "Number of connections migrated to another listening socket when their listening socket closed."),
+		MigrateReqFailure:                  mustCreateMetric("/netstack/tcp/migrate_req_failure", "Number of connections that could not be migrated to another listening socket when their listening socket closed."),
 		FailedConnectionAttempts:           mustCreateMetric("/netstack/tcp/failed_connection_attempts", "Number of calls to Connect or Listen (active and passive openings, respectively) that end in an error."),
 		ValidSegmentsReceived:              mustCreateMetric("/netstack/tcp/valid_segments_received", "Number of TCP segments received that the transport layer successfully parsed."),
 		InvalidSegmentsReceived:            mustCreateMetric("/netstack/tcp/invalid_segments_received", "Number of TCP segments received that the transport layer could not parse."),
@@ -223,10 +230,12 @@ var Metrics = tcpip.Stats{
 		Retransmits:                        mustCreateMetric("/netstack/tcp/retransmits", "Number of TCP segments retransmitted."),
 		FastRecovery:                       mustCreateMetric("/netstack/tcp/fast_recovery", "Number of times fast recovery was used to recover from packet loss."),
 		SACKRecovery:                       mustCreateMetric("/netstack/tcp/sack_recovery", "Number of times SACK recovery was used to recover from packet loss."),
+		ECNCongestionEvents:                mustCreateMetric("/netstack/tcp/ecn_congestion_events", "Number of times the congestion window was reduced in response to ECN feedback."),
 		SlowStartRetransmits:               mustCreateMetric("/netstack/tcp/slow_start_retransmits", "Number of segments retransmitted in slow start mode."),
 		FastRetransmit:                     mustCreateMetric("/netstack/tcp/fast_retransmit", "Number of TCP segments which were fast retransmitted."),
 		Timeouts:                           mustCreateMetric("/netstack/tcp/timeouts", "Number of times RTO expired."),
 		ChecksumErrors:                     mustCreateMetric("/netstack/tcp/checksum_errors", "Number of segments dropped due to bad checksums."),
+		MD5SignatureErrors:                 mustCreateMetric("/netstack/tcp/md5_signature_errors", "Number of segments dropped due to a missing, unexpected or invalid TCP MD5 signature."),
 	},
 	UDP: tcpip.UDPStats{
 		PacketsReceived:          mustCreateMetric("/netstack/udp/packets_received", "Number of UDP datagrams received via HandlePacket."),
@@ -245,6 +254,8 @@ const DefaultTTL = 64
 
 const sizeOfInt32 int = 4
 
+const sizeOfInt16 int = 2
+
 var errStackType = syserr.New("expected but did not receive a netstack.Stack", linux.EINVAL)
 
 // commonEndpoint represents the intersection of a tcpip.Endpoint and a
@@ -328,6 +339,20 @@ type socketOpsCommon struct {
 	// false, the same timestamp is instead stored and can be read via the
 	// SIOCGSTAMP ioctl. It is protected by readMu. See socket(7).
 	sockOptTimestamp bool
+	// sockOptTimestampNS corresponds to SO_TIMESTAMPNS. When true,
+	// sockOptTimestamp is also true and timestamps are returned with
+	// nanosecond resolution. It is protected by readMu.
+	sockOptTimestampNS bool
+	// sockOptTimestamping holds the SOF_TIMESTAMPING_* flags set with
+	// SO_TIMESTAMPING. It is protected by readMu.
+	sockOptTimestamping uint32
+	// timestampingKey is the next SOF_TIMESTAMPING_OPT_ID key. For datagram
+	// sockets it counts sendmsg calls and for stream sockets it counts bytes
+	// sent. It is protected by readMu.
+	timestampingKey uint32
+	// zeroCopyKey is the number of the next MSG_ZEROCOPY send reported on
+	// the error queue. It is protected by readMu.
+	zeroCopyKey uint32
 	// timestampValid indicates whether timestamp for SIOCGSTAMP has been
 	// set. It is protected by readMu.
 	timestampValid bool
@@ -338,6 +363,18 @@ type socketOpsCommon struct {
 	// TODO(b/153685824): Move this to SocketOptions.
 	// sockOptInq corresponds to TCP_INQ.
 	sockOptInq bool
+
+	// tlsMu protects the kTLS state below. When both are needed, readMu
+	// must be locked before tlsMu, which is locked before tlsTX.mu.
+	tlsMu sync.Mutex `state:"nosave"`
+	// tlsULP is set once the "tls" upper layer protocol is set with
+	// TCP_ULP. It is protected by tlsMu.
+	tlsULP bool
+	// tlsTX is the state set with TLS_TX. It is protected by tlsMu.
+	tlsTX *tlsCipher
+	// tlsRX is the state set with TLS_RX. It is protected by tlsMu for
+	// writes, and by either readMu or tlsMu for reads.
+	tlsRX *tlsReceiver
 }
 
 // New creates a new endpoint socket.
@@ -409,7 +446,7 @@ func (s *SocketOperations) Read(ctx context.Context, _ *fs.File, dst usermem.IOS
 	if dst.NumBytes() == 0 {
 		return 0, nil
 	}
-	n, _, _, _, _, err := s.nonBlockingRead(ctx, dst, false, false, false)
+	n, _, _, _, _, err := s.nonBlockingRead(ctx, dst, false, false, false, 0)
 	if err == syserr.ErrWouldBlock {
 		return int64(n), syserror.ErrWouldBlock
 	}
@@ -424,6 +461,14 @@ func (s *SocketOperations) WriteTo(ctx context.Context, _ *fs.File, dst io.Write
 	s.readMu.Lock()
 	defer s.readMu.Unlock()
 
+	if rx := s.tlsRX; rx != nil {
+		n, _, err := s.tlsRead(rx, dst, count, dup, 0)
+		if err != nil {
+			return 0, err.ToError()
+		}
+		return int64(n), nil
+	}
+
 	w := tcpip.LimitedWriter{
 		W: dst,
 		N: count,
@@ -442,7 +487,7 @@ func (s *SocketOperations) WriteTo(ctx context.Context, _ *fs.File, dst io.Write
 // Write implements fs.FileOperations.Write.
 func (s *SocketOperations) Write(ctx context.Context, _ *fs.File, src usermem.IOSequence, _ int64) (int64, error) {
 	r := src.Reader(ctx)
-	n, err := s.Endpoint.Write(r, tcpip.WriteOptions{})
+	n, err := s.write(r, tcpip.WriteOptions{})
 	if err == tcpip.ErrWouldBlock {
 		return 0, syserror.ErrWouldBlock
 	}
@@ -475,7 +520,7 @@ func (s *SocketOperations) ReadFrom(ctx context.Context, _ *fs.File, r io.Reader
 			N: count,
 		},
 	}
-	n, err := s.Endpoint.Write(&f, tcpip.WriteOptions{
+	n, err := s.write(&f, tcpip.WriteOptions{
 		// Reads may be destructive but should be very fast,
 		// so we can't release the lock while copying data.
 		Atomic: true,
@@ -488,7 +533,13 @@ func (s *SocketOperations) ReadFrom(ctx context.Context, _ *fs.File, r io.Reader
 
 // Readiness returns a mask of ready events for socket s.
 func (s *socketOpsCommon) Readiness(mask waiter.EventMask) waiter.EventMask {
-	return s.Endpoint.Readiness(mask)
+	ready := s.Endpoint.Readiness(mask) | s.tlsReadiness(mask)
+	// A non-empty error queue is always reported, as with sk_error_queue in
+	// net/ipv4/tcp.c:tcp_poll() and net/core/datagram.c:datagram_poll().
+	if s.Endpoint.SocketOptions().PeekErr() != nil {
+		ready |= waiter.EventErr
+	}
+	return ready
 }
 
 func (s *socketOpsCommon) checkFamily(family uint16, exact bool) *syserr.Error {
@@ -740,17 +791,8 @@ func (s *SocketOperations) GetSockOpt(t *kernel.Task, level, name int, outPtr us
 	// commonEndpoint. commonEndpoint should be extended to support socket
 	// options where the implementation is not shared, as unix sockets need
 	// their own support for SO_TIMESTAMP.
-	if level == linux.SOL_SOCKET && name == linux.SO_TIMESTAMP {
-		if outLen < sizeOfInt32 {
-			return nil, syserr.ErrInvalidArgument
-		}
-		val := primitive.Int32(0)
-		s.readMu.Lock()
-		defer s.readMu.Unlock()
-		if s.sockOptTimestamp {
-			val = 1
-		}
-		return &val, nil
+	if level == linux.SOL_SOCKET && isTimestampOption(name) {
+		return s.getSockOptTimestamp(name, outLen)
 	}
 	if level == linux.SOL_TCP && name == linux.TCP_INQ {
 		if outLen < sizeOfInt32 {
@@ -764,6 +806,9 @@ func (s *SocketOperations) GetSockOpt(t *kernel.Task, level, name int, outPtr us
 		}
 		return &val, nil
 	}
+	if isTLSOption(level, name) {
+		return s.getSockOptTLS(level, name, outLen)
+	}
 
 	return GetSockOpt(t, s, s.Endpoint, s.family, s.skType, level, name, outPtr, outLen)
 }
@@ -784,8 +829,10 @@ func GetSockOpt(t *kernel.Task, s socket.SocketOps, ep commonEndpoint, family in
 	case linux.SOL_IP:
 		return getSockOptIP(t, s, ep, name, outPtr, outLen, family)
 
-	case linux.SOL_UDP,
-		linux.SOL_ICMPV6,
+	case linux.SOL_UDP:
+		return getSockOptUDP(t, s, ep, name, outLen)
+
+	case linux.SOL_ICMPV6,
 		linux.SOL_RAW,
 		linux.SOL_PACKET:
 
@@ -923,6 +970,14 @@ func getSockOptSocket(t *kernel.Task, s socket.SocketOps, ep commonEndpoint, fam
 		v := primitive.Int32(boolToInt32(ep.SocketOptions().GetBroadcast()))
 		return &v, nil
 
+	case linux.SO_ZEROCOPY:
+		if outLen < sizeOfInt32 {
+			return nil, syserr.ErrInvalidArgument
+		}
+
+		v := primitive.Int32(boolToInt32(ep.SocketOptions().GetZeroCopy()))
+		return &v, nil
+
 	case linux.SO_KEEPALIVE:
 		if outLen < sizeOfInt32 {
 			return nil, syserr.ErrInvalidArgument
@@ -979,6 +1034,30 @@ func getSockOptSocket(t *kernel.Task, s socket.SocketOps, ep commonEndpoint, fam
 		v := primitive.Int32(boolToInt32(ep.SocketOptions().GetNoChecksum()))
 		return &v, nil
 
+	case linux.SO_BUSY_POLL:
+		if outLen < sizeOfInt32 {
+			return nil, syserr.ErrInvalidArgument
+		}
+
+		v := primitive.Int32(ep.SocketOptions().GetBusyPoll())
+		return &v, nil
+
+	case linux.SO_MARK:
+		if outLen < sizeOfInt32 {
+			return nil, syserr.ErrInvalidArgument
+		}
+
+		v := primitive.Int32(ep.SocketOptions().GetMark())
+		return &v, nil
+
+	case linux.SO_INCOMING_CPU:
+		if outLen < sizeOfInt32 {
+			return nil, syserr.ErrInvalidArgument
+		}
+
+		v := primitive.Int32(ep.SocketOptions().GetIncomingCPU())
+		return &v, nil
+
 	case linux.SO_ACCEPTCONN:
 		if outLen < sizeOfInt32 {
 			return nil, syserr.ErrInvalidArgument
@@ -1194,6 +1273,30 @@ func getSockOptTCP(t *kernel.Task, s socket.SocketOps, ep commonEndpoint, name,
 		}
 		vP := primitive.Int32(v)
 		return &vP, nil
+
+	case linux.TCP_FASTOPEN:
+		if outLen < sizeOfInt32 {
+			return nil, syserr.ErrInvalidArgument
+		}
+
+		v, err := ep.GetSockOptInt(tcpip.TCPFastOpenOption)
+		if err != nil {
+			return nil, syserr.TranslateNetstackError(err)
+		}
+		vP := primitive.Int32(v)
+		return &vP, nil
+
+	case linux.TCP_FASTOPEN_CONNECT:
+		if outLen < sizeOfInt32 {
+			return nil, syserr.ErrInvalidArgument
+		}
+
+		v, err := ep.GetSockOptInt(tcpip.TCPFastOpenConnectOption)
+		if err != nil {
+			return nil, syserr.TranslateNetstackError(err)
+		}
+		vP := primitive.Int32(v)
+		return &vP, nil
 	default:
 		emitUnimplementedEventTCP(t, name)
 	}
@@ -1201,6 +1304,44 @@ func getSockOptTCP(t *kernel.Task, s socket.SocketOps, ep commonEndpoint, name,
 }
 
 // getSockOptIPv6 implements GetSockOpt when level is SOL_IPV6.
+// getSockOptUDP implements GetSockOpt when level is SOL_UDP.
+func getSockOptUDP(t *kernel.Task, s socket.SocketOps, ep commonEndpoint, name, outLen int) (marshal.Marshallable, *syserr.Error) {
+	if _, skType, skProto := s.Type(); !isUDPSocket(skType, skProto) {
+		log.Warningf("SOL_UDP options are only supported on UDP sockets: skType, skProto = %v, %d", skType, skProto)
+		return nil, syserr.ErrUnknownProtocolOption
+	}
+
+	switch name {
+	case linux.UDP_SEGMENT:
+		if outLen < sizeOfInt32 {
+			return nil, syserr.ErrInvalidArgument
+		}
+
+		v, err := ep.GetSockOptInt(tcpip.UDPSegmentOption)
+		if err != nil {
+			return nil, syserr.TranslateNetstackError(err)
+		}
+		vP := primitive.Int32(v)
+		return &vP, nil
+
+	case linux.UDP_GRO:
+		if outLen < sizeOfInt32 {
+			return nil, syserr.ErrInvalidArgument
+		}
+
+		v, err := ep.GetSockOptInt(tcpip.UDPGROOption)
+		if err != nil {
+			return nil, syserr.TranslateNetstackError(err)
+		}
+		vP := primitive.Int32(v)
+		return &vP, nil
+
+	default:
+		emitUnimplementedEventUDP(t, name)
+	}
+	return nil, syserr.ErrProtocolNotAvailable
+}
+
 func getSockOptIPv6(t *kernel.Task, s socket.SocketOps, ep commonEndpoint, name int, outPtr usermem.Addr, outLen int) (marshal.Marshallable, *syserr.Error) {
 	if _, ok := ep.(tcpip.Endpoint); !ok {
 		log.Warningf("SOL_IPV6 options not supported on endpoints other than tcpip.Endpoint: option = %d", name)
@@ -1221,6 +1362,9 @@ func getSockOptIPv6(t *kernel.Task, s socket.SocketOps, ep commonEndpoint, name
 		v := primitive.Int32(boolToInt32(ep.SocketOptions().GetV6Only()))
 		return &v, nil
 
+	case linux.MRT6_VERSION, linux.MRT6_ASSERT:
+		return getSockOptMulticastRouting(s, ep, linux.AF_INET6, name, outLen)
+
 	case linux.IPV6_PATHMTU:
 		t.Kernel().EmitUnimplementedEvent(t)
 
@@ -1261,6 +1405,42 @@ func getSockOptIPv6(t *kernel.Task, s socket.SocketOps, ep commonEndpoint, name
 		v := primitive.Int32(boolToInt32(ep.SocketOptions().GetRecvError()))
 		return &v, nil
 
+	case linux.IPV6_RECVPKTINFO:
+		if outLen < sizeOfInt32 {
+			return nil, syserr.ErrInvalidArgument
+		}
+
+		v := primitive.Int32(boolToInt32(ep.SocketOptions().GetReceiveIPv6PacketInfo()))
+		return &v, nil
+
+	case linux.IPV6_RECVHOPLIMIT:
+		if outLen < sizeOfInt32 {
+			return nil, syserr.ErrInvalidArgument
+		}
+
+		v := primitive.Int32(boolToInt32(ep.SocketOptions().GetReceiveHopLimit()))
+		return &v, nil
+
+	case linux.IPV6_FLOWINFO:
+		if outLen < sizeOfInt32 {
+			return nil, syserr.ErrInvalidArgument
+		}
+
+		v := primitive.Int32(boolToInt32(ep.SocketOptions().GetReceiveFlowInfo()))
+		return &v, nil
+
+	case linux.IPV6_HDRINCL:
+		// Only valid for raw IPv6 sockets.
+		if skType != linux.SOCK_RAW {
+			return nil, syserr.ErrProtocolNotAvailable
+		}
+		if outLen < sizeOfInt32 {
+			return nil, syserr.ErrInvalidArgument
+		}
+
+		v := primitive.Int32(boolToInt32(ep.SocketOptions().GetHeaderIncluded()))
+		return &v, nil
+
 	case linux.IPV6_RECVORIGDSTADDR:
 		if outLen < sizeOfInt32 {
 			return nil, syserr.ErrInvalidArgument
@@ -1269,6 +1449,14 @@ func getSockOptIPv6(t *kernel.Task, s socket.SocketOps, ep commonEndpoint, name
 		v := primitive.Int32(boolToInt32(ep.SocketOptions().GetReceiveOriginalDstAddress()))
 		return &v, nil
 
+	case linux.IPV6_TRANSPARENT:
+		if outLen < sizeOfInt32 {
+			return nil, syserr.ErrInvalidArgument
+		}
+
+		v := primitive.Int32(boolToInt32(ep.SocketOptions().GetTransparent()))
+		return &v, nil
+
 	case linux.IP6T_ORIGINAL_DST:
 		if outLen < int(binary.Size(linux.SockAddrInet6{})) {
 			return nil, syserr.ErrInvalidArgument
@@ -1348,6 +1536,36 @@ func getSockOptIPv6(t *kernel.Task, s socket.SocketOps, ep commonEndpoint, name
 	return nil, syserr.ErrProtocolNotAvailable
 }
 
+// getSockOptPktOptions returns the control messages enabled on a TCP socket, as
+// IP_PKTOPTIONS does. Like Linux, the packet information holds the local
+// address of the socket and its multicast interface, and the TTL is the
+// multicast TTL, as TCP sockets don't keep per packet information.
+func getSockOptPktOptions(t *kernel.Task, ep commonEndpoint, outLen int) marshal.Marshallable {
+	buf := make([]byte, 0, outLen)
+	if ep.SocketOptions().GetReceivePacketInfo() {
+		var info linux.ControlMessageIPPacketInfo
+		if addr, err := ep.GetLocalAddress(); err == nil && len(addr.Addr) == header.IPv4AddressSize {
+			copy(info.LocalAddr[:], addr.Addr)
+			copy(info.DestinationAddr[:], addr.Addr)
+		}
+		var mcastIf tcpip.MulticastInterfaceOption
+		if err := ep.GetSockOpt(&mcastIf); err == nil {
+			info.NIC = int32(mcastIf.NIC)
+		}
+		buf = control.PackIPPacketInfo(t, &info, buf)
+	}
+	if ep.SocketOptions().GetReceiveTTL() {
+		ttl, err := ep.GetSockOptInt(tcpip.MulticastTTLOption)
+		if err != nil {
+			// Linux's default multicast TTL.
+			ttl = 1
+		}
+		buf = control.PackTTL(t, uint8(ttl), buf)
+	}
+	b := primitive.ByteSlice(buf)
+	return &b
+}
+
 // getSockOptIP implements GetSockOpt when level is SOL_IP.
 func getSockOptIP(t *kernel.Task, s socket.SocketOps, ep commonEndpoint, name int, outPtr usermem.Addr, outLen int, family int) (marshal.Marshallable, *syserr.Error) {
 	if _, ok := ep.(tcpip.Endpoint); !ok {
@@ -1356,6 +1574,9 @@ func getSockOptIP(t *kernel.Task, s socket.SocketOps, ep commonEndpoint, name in
 	}
 
 	switch name {
+	case linux.MRT_VERSION, linux.MRT_ASSERT:
+		return getSockOptMulticastRouting(s, ep, linux.AF_INET, name, outLen)
+
 	case linux.IP_TTL:
 		if outLen < sizeOfInt32 {
 			return nil, syserr.ErrInvalidArgument
@@ -1450,6 +1671,14 @@ func getSockOptIP(t *kernel.Task, s socket.SocketOps, ep commonEndpoint, name in
 		v := primitive.Int32(boolToInt32(ep.SocketOptions().GetReceivePacketInfo()))
 		return &v, nil
 
+	case linux.IP_PKTOPTIONS:
+		// Only valid for TCP sockets, which can't pass control messages
+		// on reads.
+		if _, skType, skProto := s.Type(); !isTCPSocket(skType, skProto) {
+			return nil, syserr.ErrProtocolNotAvailable
+		}
+		return getSockOptPktOptions(t, ep, outLen), nil
+
 	case linux.IP_HDRINCL:
 		if outLen < sizeOfInt32 {
 			return nil, syserr.ErrInvalidArgument
@@ -1458,6 +1687,14 @@ func getSockOptIP(t *kernel.Task, s socket.SocketOps, ep commonEndpoint, name in
 		v := primitive.Int32(boolToInt32(ep.SocketOptions().GetHeaderIncluded()))
 		return &v, nil
 
+	case linux.IP_RECVTTL:
+		if outLen < sizeOfInt32 {
+			return nil, syserr.ErrInvalidArgument
+		}
+
+		v := primitive.Int32(boolToInt32(ep.SocketOptions().GetReceiveTTL()))
+		return &v, nil
+
 	case linux.IP_RECVORIGDSTADDR:
 		if outLen < sizeOfInt32 {
 			return nil, syserr.ErrInvalidArgument
@@ -1466,6 +1703,14 @@ func getSockOptIP(t *kernel.Task, s socket.SocketOps, ep commonEndpoint, name in
 		v := primitive.Int32(boolToInt32(ep.SocketOptions().GetReceiveOriginalDstAddress()))
 		return &v, nil
 
+	case linux.IP_TRANSPARENT:
+		if outLen < sizeOfInt32 {
+			return nil, syserr.ErrInvalidArgument
+		}
+
+		v := primitive.Int32(boolToInt32(ep.SocketOptions().GetTransparent()))
+		return &v, nil
+
 	case linux.SO_ORIGINAL_DST:
 		if outLen < int(binary.Size(linux.SockAddrInet{})) {
 			return nil, syserr.ErrInvalidArgument
@@ -1553,14 +1798,8 @@ func (s *SocketOperations) SetSockOpt(t *kernel.Task, level int, name int, optVa
 	// commonEndpoint. commonEndpoint should be extended to support socket
 	// options where the implementation is not shared, as unix sockets need
 	// their own support for SO_TIMESTAMP.
-	if level == linux.SOL_SOCKET && name == linux.SO_TIMESTAMP {
-		if len(optVal) < sizeOfInt32 {
-			return syserr.ErrInvalidArgument
-		}
-		s.readMu.Lock()
-		defer s.readMu.Unlock()
-		s.sockOptTimestamp = usermem.ByteOrder.Uint32(optVal) != 0
-		return nil
+	if level == linux.SOL_SOCKET && isTimestampOption(name) {
+		return s.setSockOptTimestamp(name, optVal)
 	}
 	if level == linux.SOL_TCP && name == linux.TCP_INQ {
 		if len(optVal) < sizeOfInt32 {
@@ -1571,10 +1810,72 @@ func (s *SocketOperations) SetSockOpt(t *kernel.Task, level int, name int, optVa
 		s.sockOptInq = usermem.ByteOrder.Uint32(optVal) != 0
 		return nil
 	}
+	if isTLSOption(level, name) {
+		return s.setSockOptTLS(level, name, optVal)
+	}
 
 	return SetSockOpt(t, s, s.Endpoint, level, name, optVal)
 }
 
+// isTimestampOption returns true if name is one of the SOL_SOCKET timestamp
+// options implemented by socketOpsCommon.
+func isTimestampOption(name int) bool {
+	switch name {
+	case linux.SO_TIMESTAMP, linux.SO_TIMESTAMPNS, linux.SO_TIMESTAMPING:
+		return true
+	default:
+		return false
+	}
+}
+
+// getSockOptTimestamp implements GetSockOpt for the options accepted by
+// isTimestampOption.
+func (s *socketOpsCommon) getSockOptTimestamp(name, outLen int) (marshal.Marshallable, *syserr.Error) {
+	if outLen < sizeOfInt32 {
+		return nil, syserr.ErrInvalidArgument
+	}
+	s.readMu.Lock()
+	defer s.readMu.Unlock()
+	var val primitive.Int32
+	switch name {
+	case linux.SO_TIMESTAMP:
+		val = primitive.Int32(boolToInt32(s.sockOptTimestamp && !s.sockOptTimestampNS))
+	case linux.SO_TIMESTAMPNS:
+		val = primitive.Int32(boolToInt32(s.sockOptTimestampNS))
+	case linux.SO_TIMESTAMPING:
+		val = primitive.Int32(s.sockOptTimestamping)
+	}
+	return &val, nil
+}
+
+// setSockOptTimestamp implements SetSockOpt for the options accepted by
+// isTimestampOption. It follows net/core/sock.c:sock_setsockopt().
+func (s *socketOpsCommon) setSockOptTimestamp(name int, optVal []byte) *syserr.Error {
+	if len(optVal) < sizeOfInt32 {
+		return syserr.ErrInvalidArgument
+	}
+	v := usermem.ByteOrder.Uint32(optVal)
+	s.readMu.Lock()
+	defer s.readMu.Unlock()
+	switch name {
+	case linux.SO_TIMESTAMP, linux.SO_TIMESTAMPNS:
+		// SO_TIMESTAMP and SO_TIMESTAMPNS share a flag: enabling one selects
+		// its format and disabling either turns timestamps off.
+		s.sockOptTimestamp = v != 0
+		s.sockOptTimestampNS = v != 0 && name == linux.SO_TIMESTAMPNS
+	case linux.SO_TIMESTAMPING:
+		if v&^linux.SOF_TIMESTAMPING_MASK != 0 {
+			return syserr.ErrInvalidArgument
+		}
+		if v&linux.SOF_TIMESTAMPING_OPT_ID != 0 && s.sockOptTimestamping&linux.SOF_TIMESTAMPING_OPT_ID == 0 {
+			// Keys are counted from the time OPT_ID is enabled.
+			s.timestampingKey = 0
+		}
+		s.sockOptTimestamping = v
+	}
+	return nil
+}
+
 // SetSockOpt can be used to implement the linux syscall setsockopt(2) for
 // sockets backed by a commonEndpoint.
 func SetSockOpt(t *kernel.Task, s socket.SocketOps, ep commonEndpoint, level int, name int, optVal []byte) *syserr.Error {
@@ -1598,8 +1899,10 @@ func SetSockOpt(t *kernel.Task, s socket.SocketOps, ep commonEndpoint, level int
 		t.Kernel().EmitUnimplementedEvent(t)
 		return syserr.ErrProtocolNotAvailable
 
-	case linux.SOL_UDP,
-		linux.SOL_ICMPV6,
+	case linux.SOL_UDP:
+		return setSockOptUDP(t, s, ep, name, optVal)
+
+	case linux.SOL_ICMPV6,
 		linux.SOL_RAW:
 
 		t.Kernel().EmitUnimplementedEvent(t)
@@ -1609,6 +1912,17 @@ func SetSockOpt(t *kernel.Task, s socket.SocketOps, ep commonEndpoint, level int
 }
 
 // setSockOptSocket implements SetSockOpt when level is SOL_SOCKET.
+// setBindToDevice binds ep to the device with the given index, or unbinds it
+// if nicID is 0.
+func setBindToDevice(t *kernel.Task, ep commonEndpoint, nicID int32) *syserr.Error {
+	// Like Linux, changing the device a socket is already bound to requires
+	// CAP_NET_RAW. See net/core/sock.c:sock_bindtoindex_locked.
+	if ep.SocketOptions().GetBindToDevice() != 0 && !t.HasCapability(linux.CAP_NET_RAW) {
+		return syserr.ErrNotPermitted
+	}
+	return syserr.TranslateNetstackError(ep.SocketOptions().SetBindToDevice(nicID))
+}
+
 func setSockOptSocket(t *kernel.Task, s socket.SocketOps, ep commonEndpoint, name int, optVal []byte) *syserr.Error {
 	switch name {
 	case linux.SO_SNDBUF:
@@ -1652,7 +1966,7 @@ func setSockOptSocket(t *kernel.Task, s socket.SocketOps, ep commonEndpoint, nam
 		}
 		name := string(optVal[:n])
 		if name == "" {
-			return syserr.TranslateNetstackError(ep.SocketOptions().SetBindToDevice(0))
+			return setBindToDevice(t, ep, 0)
 		}
 		s := t.NetworkContext()
 		if s == nil {
@@ -1660,11 +1974,22 @@ func setSockOptSocket(t *kernel.Task, s socket.SocketOps, ep commonEndpoint, nam
 		}
 		for nicID, nic := range s.Interfaces() {
 			if nic.Name == name {
-				return syserr.TranslateNetstackError(ep.SocketOptions().SetBindToDevice(nicID))
+				return setBindToDevice(t, ep, nicID)
 			}
 		}
 		return syserr.ErrUnknownDevice
 
+	case linux.SO_BINDTOIFINDEX:
+		if len(optVal) < sizeOfInt32 {
+			return syserr.ErrInvalidArgument
+		}
+
+		v := int32(usermem.ByteOrder.Uint32(optVal))
+		if v < 0 {
+			return syserr.ErrInvalidArgument
+		}
+		return setBindToDevice(t, ep, v)
+
 	case linux.SO_BROADCAST:
 		if len(optVal) < sizeOfInt32 {
 			return syserr.ErrInvalidArgument
@@ -1674,6 +1999,28 @@ func setSockOptSocket(t *kernel.Task, s socket.SocketOps, ep commonEndpoint, nam
 		ep.SocketOptions().SetBroadcast(v != 0)
 		return nil
 
+	case linux.SO_ZEROCOPY:
+		if len(optVal) < sizeOfInt32 {
+			return syserr.ErrInvalidArgument
+		}
+
+		// Only TCP and UDP sockets support MSG_ZEROCOPY. As in
+		// net/core/sock.c:sock_setsockopt(), other sockets fail with
+		// ENOTSUPP rather than EOPNOTSUPP.
+		family, skType, skProto := s.Type()
+		if family != linux.AF_INET && family != linux.AF_INET6 {
+			return syserr.ErrNotSupp
+		}
+		if !isTCPSocket(skType, skProto) && !isUDPSocket(skType, skProto) {
+			return syserr.ErrNotSupp
+		}
+		v := usermem.ByteOrder.Uint32(optVal)
+		if v > 1 {
+			return syserr.ErrInvalidArgument
+		}
+		ep.SocketOptions().SetZeroCopy(v != 0)
+		return nil
+
 	case linux.SO_PASSCRED:
 		if len(optVal) < sizeOfInt32 {
 			return syserr.ErrInvalidArgument
@@ -1741,6 +2088,43 @@ func setSockOptSocket(t *kernel.Task, s socket.SocketOps, ep commonEndpoint, nam
 		ep.SocketOptions().SetNoChecksum(v != 0)
 		return nil
 
+	case linux.SO_BUSY_POLL:
+		if len(optVal) < sizeOfInt32 {
+			return syserr.ErrInvalidArgument
+		}
+
+		v := int32(usermem.ByteOrder.Uint32(optVal))
+		if v < 0 {
+			return syserr.ErrInvalidArgument
+		}
+		// Raising the busy poll time requires CAP_NET_ADMIN, as it lets the
+		// socket burn more CPU.
+		if uint32(v) > ep.SocketOptions().GetBusyPoll() && !t.HasCapability(linux.CAP_NET_ADMIN) {
+			return syserr.ErrNotPermitted
+		}
+		ep.SocketOptions().SetBusyPoll(uint32(v))
+		return nil
+
+	case linux.SO_MARK:
+		if len(optVal) < sizeOfInt32 {
+			return syserr.ErrInvalidArgument
+		}
+
+		// The mark selects the routing rules of the packets.
+		if !t.HasCapability(linux.CAP_NET_ADMIN) && !t.HasCapability(linux.CAP_NET_RAW) {
+			return syserr.ErrNotPermitted
+		}
+		ep.SocketOptions().SetMark(usermem.ByteOrder.Uint32(optVal))
+		return nil
+
+	case linux.SO_INCOMING_CPU:
+		if len(optVal) < sizeOfInt32 {
+			return syserr.ErrInvalidArgument
+		}
+
+		ep.SocketOptions().SetIncomingCPU(int32(usermem.ByteOrder.Uint32(optVal)))
+		return nil
+
 	case linux.SO_LINGER:
 		if len(optVal) < linux.SizeOfLinger {
 			return syserr.ErrInvalidArgument
@@ -1900,6 +2284,29 @@ func setSockOptTCP(t *kernel.Task, s socket.SocketOps, ep commonEndpoint, name i
 
 		return syserr.TranslateNetstackError(ep.SetSockOptInt(tcpip.TCPWindowClampOption, int(v)))
 
+	case linux.TCP_FASTOPEN:
+		if len(optVal) < sizeOfInt32 {
+			return syserr.ErrInvalidArgument
+		}
+		v := int32(usermem.ByteOrder.Uint32(optVal))
+
+		return syserr.TranslateNetstackError(ep.SetSockOptInt(tcpip.TCPFastOpenOption, int(v)))
+
+	case linux.TCP_FASTOPEN_CONNECT:
+		if len(optVal) < sizeOfInt32 {
+			return syserr.ErrInvalidArgument
+		}
+		v := int32(usermem.ByteOrder.Uint32(optVal))
+
+		return syserr.TranslateNetstackError(ep.SetSockOptInt(tcpip.TCPFastOpenConnectOption, int(v)))
+
+	case linux.TCP_MD5SIG, linux.TCP_MD5SIG_EXT:
+		opt, err := parseTCPMD5Sig(s, name, optVal)
+		if err != nil {
+			return err
+		}
+		return syserr.TranslateNetstackError(ep.SetSockOpt(opt))
+
 	case linux.TCP_REPAIR_OPTIONS:
 		t.Kernel().EmitUnimplementedEvent(t)
 
@@ -1911,25 +2318,55 @@ func setSockOptTCP(t *kernel.Task, s socket.SocketOps, ep commonEndpoint, name i
 }
 
 // setSockOptIPv6 implements SetSockOpt when level is SOL_IPV6.
-func setSockOptIPv6(t *kernel.Task, s socket.SocketOps, ep commonEndpoint, name int, optVal []byte) *syserr.Error {
-	if _, ok := ep.(tcpip.Endpoint); !ok {
-		log.Warningf("SOL_IPV6 options not supported on endpoints other than tcpip.Endpoint: option = %d", name)
-		return syserr.ErrUnknownProtocolOption
-	}
-
-	family, skType, skProto := s.Type()
-	if family != linux.AF_INET6 {
+// setSockOptUDP implements SetSockOpt when level is SOL_UDP.
+func setSockOptUDP(t *kernel.Task, s socket.SocketOps, ep commonEndpoint, name int, optVal []byte) *syserr.Error {
+	if _, skType, skProto := s.Type(); !isUDPSocket(skType, skProto) {
+		log.Warningf("SOL_UDP options are only supported on UDP sockets: skType, skProto = %v, %d", skType, skProto)
 		return syserr.ErrUnknownProtocolOption
 	}
 
 	switch name {
-	case linux.IPV6_V6ONLY:
+	case linux.UDP_SEGMENT:
 		if len(optVal) < sizeOfInt32 {
 			return syserr.ErrInvalidArgument
 		}
 
-		if isTCPSocket(skType, skProto) && tcp.EndpointState(ep.State()) != tcp.StateInitial {
-			return syserr.ErrInvalidEndpointState
+		v := int32(usermem.ByteOrder.Uint32(optVal))
+		return syserr.TranslateNetstackError(ep.SetSockOptInt(tcpip.UDPSegmentOption, int(v)))
+
+	case linux.UDP_GRO:
+		if len(optVal) < sizeOfInt32 {
+			return syserr.ErrInvalidArgument
+		}
+
+		v := usermem.ByteOrder.Uint32(optVal)
+		return syserr.TranslateNetstackError(ep.SetSockOptInt(tcpip.UDPGROOption, int(v)))
+
+	default:
+		emitUnimplementedEventUDP(t, name)
+	}
+	return nil
+}
+
+func setSockOptIPv6(t *kernel.Task, s socket.SocketOps, ep commonEndpoint, name int, optVal []byte) *syserr.Error {
+	if _, ok := ep.(tcpip.Endpoint); !ok {
+		log.Warningf("SOL_IPV6 options not supported on endpoints other than tcpip.Endpoint: option = %d", name)
+		return syserr.ErrUnknownProtocolOption
+	}
+
+	family, skType, skProto := s.Type()
+	if family != linux.AF_INET6 {
+		return syserr.ErrUnknownProtocolOption
+	}
+
+	switch name {
+	case linux.IPV6_V6ONLY:
+		if len(optVal) < sizeOfInt32 {
+			return syserr.ErrInvalidArgument
+		}
+
+		if isTCPSocket(skType, skProto) && tcp.EndpointState(ep.State()) != tcp.StateInitial {
+			return syserr.ErrInvalidEndpointState
 		} else if isUDPSocket(skType, skProto) && udp.EndpointState(ep.State()) != udp.StateInitial {
 			return syserr.ErrInvalidEndpointState
 		}
@@ -1966,15 +2403,26 @@ func setSockOptIPv6(t *kernel.Task, s socket.SocketOps, ep commonEndpoint, name
 		// TODO(b/148887420): Add support for IPV6_PKTINFO.
 		linux.IPV6_PKTINFO,
 		linux.IPV6_ROUTER_ALERT,
-		linux.IPV6_XFRM_POLICY,
-		linux.MCAST_BLOCK_SOURCE,
-		linux.MCAST_JOIN_GROUP,
-		linux.MCAST_JOIN_SOURCE_GROUP,
+		linux.IPV6_XFRM_POLICY:
+
+		t.Kernel().EmitUnimplementedEvent(t)
+
+	case linux.MCAST_JOIN_GROUP,
 		linux.MCAST_LEAVE_GROUP,
+		linux.MCAST_JOIN_SOURCE_GROUP,
 		linux.MCAST_LEAVE_SOURCE_GROUP,
+		linux.MCAST_BLOCK_SOURCE,
 		linux.MCAST_UNBLOCK_SOURCE:
+		return setSockOptMulticastGroup(ep, linux.AF_INET6, name, optVal)
 
-		t.Kernel().EmitUnimplementedEvent(t)
+	case linux.MRT6_INIT,
+		linux.MRT6_DONE,
+		linux.MRT6_ADD_MIF,
+		linux.MRT6_DEL_MIF,
+		linux.MRT6_ADD_MFC,
+		linux.MRT6_DEL_MFC,
+		linux.MRT6_ASSERT:
+		return setSockOptMulticastRouting6(s, ep, name, optVal)
 
 	case linux.IPV6_RECVORIGDSTADDR:
 		if len(optVal) < sizeOfInt32 {
@@ -1985,6 +2433,19 @@ func setSockOptIPv6(t *kernel.Task, s socket.SocketOps, ep commonEndpoint, name
 		ep.SocketOptions().SetReceiveOriginalDstAddress(v != 0)
 		return nil
 
+	case linux.IPV6_TRANSPARENT:
+		if len(optVal) < sizeOfInt32 {
+			return syserr.ErrInvalidArgument
+		}
+		v := int32(usermem.ByteOrder.Uint32(optVal))
+
+		// Transparent sockets may use addresses not assigned to the stack.
+		if v != 0 && !t.HasCapability(linux.CAP_NET_ADMIN) && !t.HasCapability(linux.CAP_NET_RAW) {
+			return syserr.ErrNotPermitted
+		}
+		ep.SocketOptions().SetTransparent(v != 0)
+		return nil
+
 	case linux.IPV6_TCLASS:
 		if len(optVal) < sizeOfInt32 {
 			return syserr.ErrInvalidArgument
@@ -2017,6 +2478,42 @@ func setSockOptIPv6(t *kernel.Task, s socket.SocketOps, ep commonEndpoint, name
 		ep.SocketOptions().SetRecvError(v != 0)
 		return nil
 
+	case linux.IPV6_RECVPKTINFO:
+		if len(optVal) < sizeOfInt32 {
+			return syserr.ErrInvalidArgument
+		}
+		v := usermem.ByteOrder.Uint32(optVal)
+		ep.SocketOptions().SetReceiveIPv6PacketInfo(v != 0)
+		return nil
+
+	case linux.IPV6_RECVHOPLIMIT:
+		if len(optVal) < sizeOfInt32 {
+			return syserr.ErrInvalidArgument
+		}
+		v := usermem.ByteOrder.Uint32(optVal)
+		ep.SocketOptions().SetReceiveHopLimit(v != 0)
+		return nil
+
+	case linux.IPV6_FLOWINFO:
+		if len(optVal) < sizeOfInt32 {
+			return syserr.ErrInvalidArgument
+		}
+		v := usermem.ByteOrder.Uint32(optVal)
+		ep.SocketOptions().SetReceiveFlowInfo(v != 0)
+		return nil
+
+	case linux.IPV6_HDRINCL:
+		// Only valid for raw IPv6 sockets.
+		if skType != linux.SOCK_RAW {
+			return syserr.ErrProtocolNotAvailable
+		}
+		if len(optVal) < sizeOfInt32 {
+			return syserr.ErrInvalidArgument
+		}
+		v := usermem.ByteOrder.Uint32(optVal)
+		ep.SocketOptions().SetHeaderIncluded(v != 0)
+		return nil
+
 	case linux.IP6T_SO_SET_REPLACE:
 		if len(optVal) < linux.SizeOfIP6TReplace {
 			return syserr.ErrInvalidArgument
@@ -2049,6 +2546,13 @@ var (
 	inetMulticastRequestSize        = int(binary.Size(linux.InetMulticastRequest{}))
 	inetMulticastRequestWithNICSize = int(binary.Size(linux.InetMulticastRequestWithNIC{}))
 	inet6MulticastRequestSize       = int(binary.Size(linux.Inet6MulticastRequest{}))
+	inetMulticastSourceRequestSize  = int(binary.Size(linux.InetMulticastSourceRequest{}))
+	groupRequestSize                = int(binary.Size(linux.GroupRequest{}))
+	groupSourceRequestSize          = int(binary.Size(linux.GroupSourceRequest{}))
+	vifCtlSize                      = int(binary.Size(linux.VIFCtl{}))
+	mfcCtlSize                      = int(binary.Size(linux.MFCCtl{}))
+	mif6CtlSize                     = int(binary.Size(linux.MIF6Ctl{}))
+	mf6cCtlSize                     = int(binary.Size(linux.MF6CCtl{}))
 )
 
 // copyInMulticastRequest copies in a variable-size multicast request. The
@@ -2092,6 +2596,329 @@ func copyInMulticastV6Request(optVal []byte) (linux.Inet6MulticastRequest, *syse
 	return req, nil
 }
 
+// copyInMulticastSourceRequest copies in the struct ip_mreq_source passed to
+// the IP_*_SOURCE_MEMBERSHIP and IP_*BLOCK_SOURCE socket options.
+func copyInMulticastSourceRequest(optVal []byte) (tcpip.SourceMembershipOption, *syserr.Error) {
+	if len(optVal) < inetMulticastSourceRequestSize {
+		return tcpip.SourceMembershipOption{}, syserr.ErrInvalidArgument
+	}
+
+	var req linux.InetMulticastSourceRequest
+	binary.Unmarshal(optVal[:inetMulticastSourceRequestSize], usermem.ByteOrder, &req)
+	return tcpip.SourceMembershipOption{
+		InterfaceAddr: tcpip.Address(req.InterfaceAddr[:]),
+		MulticastAddr: tcpip.Address(req.MulticastAddr[:]),
+		SourceAddr:    tcpip.Address(req.SourceAddr[:]),
+	}, nil
+}
+
+// copyInGroupRequest copies in the struct group_req or struct
+// group_source_req passed to the protocol independent MCAST_* socket options.
+// The addresses must be of the given family.
+func copyInGroupRequest(optVal []byte, family int, withSource bool) (tcpip.SourceMembershipOption, *syserr.Error) {
+	var req linux.GroupSourceRequest
+	if withSource {
+		if len(optVal) < groupSourceRequestSize {
+			return tcpip.SourceMembershipOption{}, syserr.ErrInvalidArgument
+		}
+		binary.Unmarshal(optVal[:groupSourceRequestSize], usermem.ByteOrder, &req)
+	} else {
+		if len(optVal) < groupRequestSize {
+			return tcpip.SourceMembershipOption{}, syserr.ErrInvalidArgument
+		}
+		var greq linux.GroupRequest
+		binary.Unmarshal(optVal[:groupRequestSize], usermem.ByteOrder, &greq)
+		req.InterfaceIndex = greq.InterfaceIndex
+		req.Group = greq.Group
+	}
+
+	group, groupFamily, err := socket.AddressAndFamily(req.Group[:])
+	if err != nil {
+		return tcpip.SourceMembershipOption{}, err
+	}
+	if int(groupFamily) != family {
+		return tcpip.SourceMembershipOption{}, syserr.ErrInvalidArgument
+	}
+	opt := tcpip.SourceMembershipOption{
+		NIC:           tcpip.NICID(req.InterfaceIndex),
+		MulticastAddr: group.Addr,
+	}
+	if withSource {
+		source, sourceFamily, err := socket.AddressAndFamily(req.Source[:])
+		if err != nil {
+			return tcpip.SourceMembershipOption{}, err
+		}
+		if int(sourceFamily) != family {
+			return tcpip.SourceMembershipOption{}, syserr.ErrInvalidArgument
+		}
+		opt.SourceAddr = source.Addr
+	}
+	return opt, nil
+}
+
+// sourceMembershipOption returns the tcpip.SettableSocketOption that
+// implements the source-specific multicast socket option name.
+func sourceMembershipOption(name int, req tcpip.SourceMembershipOption) tcpip.SettableSocketOption {
+	switch name {
+	case linux.IP_ADD_SOURCE_MEMBERSHIP, linux.MCAST_JOIN_SOURCE_GROUP:
+		return (*tcpip.AddSourceMembershipOption)(&req)
+	case linux.IP_DROP_SOURCE_MEMBERSHIP, linux.MCAST_LEAVE_SOURCE_GROUP:
+		return (*tcpip.DropSourceMembershipOption)(&req)
+	case linux.IP_BLOCK_SOURCE, linux.MCAST_BLOCK_SOURCE:
+		return (*tcpip.BlockSourceOption)(&req)
+	case linux.IP_UNBLOCK_SOURCE, linux.MCAST_UNBLOCK_SOURCE:
+		return (*tcpip.UnblockSourceOption)(&req)
+	default:
+		panic(fmt.Sprintf("unknown source membership option %d", name))
+	}
+}
+
+// setSockOptMulticastGroup implements SetSockOpt for the protocol independent
+// MCAST_* multicast group socket options, for sockets of the given family.
+func setSockOptMulticastGroup(ep commonEndpoint, family int, name int, optVal []byte) *syserr.Error {
+	withSource := name != linux.MCAST_JOIN_GROUP && name != linux.MCAST_LEAVE_GROUP
+	req, err := copyInGroupRequest(optVal, family, withSource)
+	if err != nil {
+		return err
+	}
+
+	switch name {
+	case linux.MCAST_JOIN_GROUP:
+		return syserr.TranslateNetstackError(ep.SetSockOpt(&tcpip.AddMembershipOption{
+			NIC:           req.NIC,
+			MulticastAddr: req.MulticastAddr,
+		}))
+	case linux.MCAST_LEAVE_GROUP:
+		return syserr.TranslateNetstackError(ep.SetSockOpt(&tcpip.RemoveMembershipOption{
+			NIC:           req.NIC,
+			MulticastAddr: req.MulticastAddr,
+		}))
+	default:
+		return syserr.TranslateNetstackError(ep.SetSockOpt(sourceMembershipOption(name, req)))
+	}
+}
+
+// isMulticastRoutingSocket returns true if s may use the multicast routing
+// socket options of the given family, i.e. if it is a raw IGMP or ICMPv6
+// socket, as per net/ipv4/ipmr.c:ip_mroute_setsockopt and
+// net/ipv6/ip6mr.c:ip6_mroute_setsockopt.
+func isMulticastRoutingSocket(s socket.SocketOps, family int) bool {
+	_, skType, protocol := s.Type()
+	if skType != linux.SOCK_RAW {
+		return false
+	}
+	if family == linux.AF_INET6 {
+		return protocol == linux.IPPROTO_ICMPV6
+	}
+	return protocol == linux.IPPROTO_IGMP
+}
+
+// setSockOptMulticastRouting implements SetSockOpt for the MRT_* IPv4
+// multicast routing socket options.
+func setSockOptMulticastRouting(s socket.SocketOps, ep commonEndpoint, name int, optVal []byte) *syserr.Error {
+	if !isMulticastRoutingSocket(s, linux.AF_INET) {
+		return syserr.ErrEndpointOperation
+	}
+
+	switch name {
+	case linux.MRT_INIT:
+		if len(optVal) != sizeOfInt32 {
+			return syserr.ErrInvalidArgument
+		}
+		return syserr.TranslateNetstackError(ep.SetSockOptInt(tcpip.MulticastRouterOption, 1))
+
+	case linux.MRT_DONE:
+		return syserr.TranslateNetstackError(ep.SetSockOptInt(tcpip.MulticastRouterOption, 0))
+
+	case linux.MRT_ADD_VIF, linux.MRT_DEL_VIF:
+		if len(optVal) < vifCtlSize {
+			return syserr.ErrInvalidArgument
+		}
+		var vif linux.VIFCtl
+		binary.Unmarshal(optVal[:vifCtlSize], usermem.ByteOrder, &vif)
+		if vif.VIFI >= linux.MAXVIFS {
+			return syserr.ErrFileTableOverflow
+		}
+
+		if name == linux.MRT_DEL_VIF {
+			return syserr.TranslateNetstackError(ep.SetSockOpt(&tcpip.RemoveMulticastInterfaceOption{Index: vif.VIFI}))
+		}
+
+		// Tunnel and PIM register interfaces are not supported.
+		if vif.Flags&(linux.VIFF_TUNNEL|linux.VIFF_REGISTER) != 0 {
+			return syserr.ErrEndpointOperation
+		}
+		opt := tcpip.AddMulticastInterfaceOption{Index: vif.VIFI}
+		if vif.Flags&linux.VIFF_USE_IFINDEX != 0 {
+			opt.NIC = tcpip.NICID(int32(usermem.ByteOrder.Uint32(vif.LocalAddr[:])))
+			if opt.NIC <= 0 {
+				return syserr.ErrInvalidArgument
+			}
+		} else {
+			opt.InterfaceAddr = tcpip.Address(vif.LocalAddr[:])
+		}
+		return syserr.TranslateNetstackError(ep.SetSockOpt(&opt))
+
+	case linux.MRT_ADD_MFC, linux.MRT_DEL_MFC:
+		if len(optVal) < mfcCtlSize {
+			return syserr.ErrInvalidArgument
+		}
+		var mfc linux.MFCCtl
+		binary.Unmarshal(optVal[:mfcCtlSize], usermem.ByteOrder, &mfc)
+
+		if name == linux.MRT_DEL_MFC {
+			return syserr.TranslateNetstackError(ep.SetSockOpt(&tcpip.RemoveMulticastRouteOption{
+				Source: tcpip.Address(mfc.Origin[:]),
+				Group:  tcpip.Address(mfc.McastGrp[:]),
+			}))
+		}
+
+		if mfc.Parent >= linux.MAXVIFS {
+			return syserr.ErrFileTableOverflow
+		}
+		return syserr.TranslateNetstackError(ep.SetSockOpt(&tcpip.AddMulticastRouteOption{
+			Source:         tcpip.Address(mfc.Origin[:]),
+			Group:          tcpip.Address(mfc.McastGrp[:]),
+			InputInterface: mfc.Parent,
+			TTLs:           mfc.TTLs[:],
+		}))
+
+	case linux.MRT_ASSERT:
+		if len(optVal) < sizeOfInt32 {
+			return syserr.ErrInvalidArgument
+		}
+		v := usermem.ByteOrder.Uint32(optVal)
+		return syserr.TranslateNetstackError(ep.SetSockOptInt(tcpip.MulticastRouterAssertOption, int(v)))
+
+	default:
+		return syserr.ErrProtocolNotAvailable
+	}
+}
+
+// setSockOptMulticastRouting6 implements SetSockOpt for the MRT6_* IPv6
+// multicast routing socket options.
+func setSockOptMulticastRouting6(s socket.SocketOps, ep commonEndpoint, name int, optVal []byte) *syserr.Error {
+	if !isMulticastRoutingSocket(s, linux.AF_INET6) {
+		return syserr.ErrEndpointOperation
+	}
+
+	switch name {
+	case linux.MRT6_INIT:
+		if len(optVal) < sizeOfInt32 {
+			return syserr.ErrInvalidArgument
+		}
+		if v := usermem.ByteOrder.Uint32(optVal); v != 1 {
+			return syserr.ErrProtocolNotAvailable
+		}
+		return syserr.TranslateNetstackError(ep.SetSockOptInt(tcpip.MulticastRouterOption, 1))
+
+	case linux.MRT6_DONE:
+		return syserr.TranslateNetstackError(ep.SetSockOptInt(tcpip.MulticastRouterOption, 0))
+
+	case linux.MRT6_ADD_MIF:
+		if len(optVal) < mif6CtlSize {
+			return syserr.ErrInvalidArgument
+		}
+		var mif linux.MIF6Ctl
+		binary.Unmarshal(optVal[:mif6CtlSize], usermem.ByteOrder, &mif)
+		if mif.MIFI >= linux.MAXMIFS {
+			return syserr.ErrFileTableOverflow
+		}
+		// PIM register interfaces are not supported.
+		if mif.Flags&linux.MIFF_REGISTER != 0 {
+			return syserr.ErrEndpointOperation
+		}
+		if mif.PIFI == 0 {
+			return syserr.ErrInvalidArgument
+		}
+		return syserr.TranslateNetstackError(ep.SetSockOpt(&tcpip.AddMulticastInterfaceOption{
+			Index: mif.MIFI,
+			NIC:   tcpip.NICID(mif.PIFI),
+		}))
+
+	case linux.MRT6_DEL_MIF:
+		if len(optVal) < sizeOfInt16 {
+			return syserr.ErrInvalidArgument
+		}
+		mifi := usermem.ByteOrder.Uint16(optVal)
+		return syserr.TranslateNetstackError(ep.SetSockOpt(&tcpip.RemoveMulticastInterfaceOption{Index: mifi}))
+
+	case linux.MRT6_ADD_MFC, linux.MRT6_DEL_MFC:
+		if len(optVal) < mf6cCtlSize {
+			return syserr.ErrInvalidArgument
+		}
+		var mfc linux.MF6CCtl
+		binary.Unmarshal(optVal[:mf6cCtlSize], usermem.ByteOrder, &mfc)
+
+		if name == linux.MRT6_DEL_MFC {
+			return syserr.TranslateNetstackError(ep.SetSockOpt(&tcpip.RemoveMulticastRouteOption{
+				Source: tcpip.Address(mfc.Origin.Addr[:]),
+				Group:  tcpip.Address(mfc.McastGrp.Addr[:]),
+			}))
+		}
+
+		if mfc.Parent >= linux.MAXMIFS {
+			return syserr.ErrFileTableOverflow
+		}
+		// As per net/ipv6/ip6mr.c:ip6mr_mfc_add, packets are forwarded through
+		// the interfaces of the set if their hop limit exceeds 1.
+		ttls := make([]uint8, linux.MAXMIFS)
+		for i := range ttls {
+			if mfc.IFSet.IsSet(i) {
+				ttls[i] = 1
+			}
+		}
+		return syserr.TranslateNetstackError(ep.SetSockOpt(&tcpip.AddMulticastRouteOption{
+			Source:         tcpip.Address(mfc.Origin.Addr[:]),
+			Group:          tcpip.Address(mfc.McastGrp.Addr[:]),
+			InputInterface: mfc.Parent,
+			TTLs:           ttls,
+		}))
+
+	case linux.MRT6_ASSERT:
+		if len(optVal) < sizeOfInt32 {
+			return syserr.ErrInvalidArgument
+		}
+		v := usermem.ByteOrder.Uint32(optVal)
+		return syserr.TranslateNetstackError(ep.SetSockOptInt(tcpip.MulticastRouterAssertOption, int(v)))
+
+	default:
+		return syserr.ErrProtocolNotAvailable
+	}
+}
+
+// getSockOptMulticastRouting implements GetSockOpt for the MRT_* and MRT6_*
+// multicast routing socket options of the given family.
+func getSockOptMulticastRouting(s socket.SocketOps, ep commonEndpoint, family int, name int, outLen int) (marshal.Marshallable, *syserr.Error) {
+	if !isMulticastRoutingSocket(s, family) {
+		return nil, syserr.ErrEndpointOperation
+	}
+	if outLen < sizeOfInt32 {
+		return nil, syserr.ErrInvalidArgument
+	}
+
+	// The MRT6_* options have the same values as the MRT_* options.
+	switch name {
+	case linux.MRT_VERSION:
+		v := primitive.Int32(linux.MRT_VERSION_VALUE)
+		if family == linux.AF_INET6 {
+			v = primitive.Int32(linux.MRT6_VERSION_VALUE)
+		}
+		return &v, nil
+
+	case linux.MRT_ASSERT:
+		v, err := ep.GetSockOptInt(tcpip.MulticastRouterAssertOption)
+		if err != nil {
+			return nil, syserr.TranslateNetstackError(err)
+		}
+		vP := primitive.Int32(v)
+		return &vP, nil
+
+	default:
+		return nil, syserr.ErrProtocolNotAvailable
+	}
+}
+
 // parseIntOrChar copies either a 32-bit int or an 8-bit uint out of buf.
 //
 // net/ipv4/ip_sockglue.c:do_ip_setsockopt does this for its socket options.
@@ -2178,10 +3005,33 @@ func setSockOptIP(t *kernel.Task, s socket.SocketOps, ep commonEndpoint, name in
 		ep.SocketOptions().SetMulticastLoop(v != 0)
 		return nil
 
-	case linux.MCAST_JOIN_GROUP:
-		// FIXME(b/124219304): Implement MCAST_JOIN_GROUP.
-		t.Kernel().EmitUnimplementedEvent(t)
-		return syserr.ErrInvalidArgument
+	case linux.IP_ADD_SOURCE_MEMBERSHIP,
+		linux.IP_DROP_SOURCE_MEMBERSHIP,
+		linux.IP_BLOCK_SOURCE,
+		linux.IP_UNBLOCK_SOURCE:
+		req, err := copyInMulticastSourceRequest(optVal)
+		if err != nil {
+			return err
+		}
+
+		return syserr.TranslateNetstackError(ep.SetSockOpt(sourceMembershipOption(name, req)))
+
+	case linux.MCAST_JOIN_GROUP,
+		linux.MCAST_LEAVE_GROUP,
+		linux.MCAST_JOIN_SOURCE_GROUP,
+		linux.MCAST_LEAVE_SOURCE_GROUP,
+		linux.MCAST_BLOCK_SOURCE,
+		linux.MCAST_UNBLOCK_SOURCE:
+		return setSockOptMulticastGroup(ep, linux.AF_INET, name, optVal)
+
+	case linux.MRT_INIT,
+		linux.MRT_DONE,
+		linux.MRT_ADD_VIF,
+		linux.MRT_DEL_VIF,
+		linux.MRT_ADD_MFC,
+		linux.MRT_DEL_MFC,
+		linux.MRT_ASSERT:
+		return setSockOptMulticastRouting(s, ep, name, optVal)
 
 	case linux.IP_TTL:
 		v, err := parseIntOrChar(optVal)
@@ -2248,6 +3098,17 @@ func setSockOptIP(t *kernel.Task, s socket.SocketOps, ep commonEndpoint, name in
 		ep.SocketOptions().SetHeaderIncluded(v != 0)
 		return nil
 
+	case linux.IP_RECVTTL:
+		if len(optVal) == 0 {
+			return nil
+		}
+		v, err := parseIntOrChar(optVal)
+		if err != nil {
+			return err
+		}
+		ep.SocketOptions().SetReceiveTTL(v != 0)
+		return nil
+
 	case linux.IP_RECVORIGDSTADDR:
 		if len(optVal) == 0 {
 			return nil
@@ -2260,6 +3121,22 @@ func setSockOptIP(t *kernel.Task, s socket.SocketOps, ep commonEndpoint, name in
 		ep.SocketOptions().SetReceiveOriginalDstAddress(v != 0)
 		return nil
 
+	case linux.IP_TRANSPARENT:
+		if len(optVal) == 0 {
+			return nil
+		}
+		v, err := parseIntOrChar(optVal)
+		if err != nil {
+			return err
+		}
+
+		// Transparent sockets may use addresses not assigned to the stack.
+		if v != 0 && !t.HasCapability(linux.CAP_NET_ADMIN) && !t.HasCapability(linux.CAP_NET_RAW) {
+			return syserr.ErrNotPermitted
+		}
+		ep.SocketOptions().SetTransparent(v != 0)
+		return nil
+
 	case linux.IPT_SO_SET_REPLACE:
 		if len(optVal) < linux.SizeOfIPTReplace {
 			return syserr.ErrInvalidArgument
@@ -2281,11 +3158,8 @@ func setSockOptIP(t *kernel.Task, s socket.SocketOps, ep commonEndpoint, name in
 		// TODO(gvisor.dev/issue/170): Counter support.
 		return nil
 
-	case linux.IP_ADD_SOURCE_MEMBERSHIP,
-		linux.IP_BIND_ADDRESS_NO_PORT,
-		linux.IP_BLOCK_SOURCE,
+	case linux.IP_BIND_ADDRESS_NO_PORT,
 		linux.IP_CHECKSUM,
-		linux.IP_DROP_SOURCE_MEMBERSHIP,
 		linux.IP_FREEBIND,
 		linux.IP_IPSEC_POLICY,
 		linux.IP_MINTTL,
@@ -2297,18 +3171,10 @@ func setSockOptIP(t *kernel.Task, s socket.SocketOps, ep commonEndpoint, name in
 		linux.IP_PASSSEC,
 		linux.IP_RECVFRAGSIZE,
 		linux.IP_RECVOPTS,
-		linux.IP_RECVTTL,
 		linux.IP_RETOPTS,
-		linux.IP_TRANSPARENT,
-		linux.IP_UNBLOCK_SOURCE,
 		linux.IP_UNICAST_IF,
 		linux.IP_XFRM_POLICY,
-		linux.MCAST_BLOCK_SOURCE,
-		linux.MCAST_JOIN_SOURCE_GROUP,
-		linux.MCAST_LEAVE_GROUP,
-		linux.MCAST_LEAVE_SOURCE_GROUP,
-		linux.MCAST_MSFILTER,
-		linux.MCAST_UNBLOCK_SOURCE:
+		linux.MCAST_MSFILTER:
 
 		t.Kernel().EmitUnimplementedEvent(t)
 	}
@@ -2316,6 +3182,61 @@ func setSockOptIP(t *kernel.Task, s socket.SocketOps, ep commonEndpoint, name in
 	return nil
 }
 
+// parseTCPMD5Sig converts the struct tcp_md5sig passed to the TCP_MD5SIG or
+// TCP_MD5SIG_EXT socket options into a tcpip.TCPMD5SigOption.
+func parseTCPMD5Sig(s socket.SocketOps, name int, optVal []byte) (*tcpip.TCPMD5SigOption, *syserr.Error) {
+	if len(optVal) < linux.SizeOfTCPMD5Sig {
+		return nil, syserr.ErrInvalidArgument
+	}
+	var sig linux.TCPMD5Sig
+	binary.Unmarshal(optVal[:linux.SizeOfTCPMD5Sig], usermem.ByteOrder, &sig)
+	if int(sig.KeyLen) > linux.TCP_MD5SIG_MAXKEYLEN {
+		return nil, syserr.ErrInvalidArgument
+	}
+
+	// The address family must match the one of the socket. IPv4-mapped
+	// addresses are used for the IPv4 peers of IPv6 sockets.
+	family, _, _ := s.Type()
+	if int(usermem.ByteOrder.Uint16(sig.Addr[:])) != family {
+		return nil, syserr.ErrInvalidArgument
+	}
+	var addr tcpip.Address
+	switch family {
+	case linux.AF_INET:
+		// struct sockaddr_in: family, port and then the address.
+		addr = tcpip.Address(sig.Addr[4 : 4+header.IPv4AddressSize])
+	case linux.AF_INET6:
+		// struct sockaddr_in6: family, port, flow info and then the
+		// address.
+		addr = tcpip.Address(sig.Addr[8 : 8+header.IPv6AddressSize])
+		if header.IsV4MappedAddress(addr) {
+			addr = addr[header.IPv6AddressSize-header.IPv4AddressSize:]
+		}
+	default:
+		return nil, syserr.ErrInvalidArgument
+	}
+
+	prefixLen := len(addr) * 8
+	if name == linux.TCP_MD5SIG_EXT {
+		if sig.Flags&linux.TCP_MD5SIG_FLAG_PREFIX != 0 {
+			if int(sig.PrefixLen) > prefixLen {
+				return nil, syserr.ErrInvalidArgument
+			}
+			prefixLen = int(sig.PrefixLen)
+		}
+		// Keys bound to an interface are not supported.
+		if sig.Flags&linux.TCP_MD5SIG_FLAG_IFINDEX != 0 && sig.IfIndex != 0 {
+			return nil, syserr.ErrNotSupported
+		}
+	}
+
+	return &tcpip.TCPMD5SigOption{
+		Addr:      addr,
+		PrefixLen: prefixLen,
+		Key:       sig.Key[:sig.KeyLen],
+	}, nil
+}
+
 // emitUnimplementedEventTCP emits unimplemented event if name is valid. This
 // function contains names that are common between Get and SetSockOpt when
 // level is SOL_TCP.
@@ -2323,8 +3244,6 @@ func emitUnimplementedEventTCP(t *kernel.Task, name int) {
 	switch name {
 	case linux.TCP_CONGESTION,
 		linux.TCP_CORK,
-		linux.TCP_FASTOPEN,
-		linux.TCP_FASTOPEN_CONNECT,
 		linux.TCP_FASTOPEN_KEY,
 		linux.TCP_FASTOPEN_NO_COOKIE,
 		linux.TCP_QUEUE_SEQ,
@@ -2335,8 +3254,21 @@ func emitUnimplementedEventTCP(t *kernel.Task, name int) {
 		linux.TCP_SAVE_SYN,
 		linux.TCP_THIN_DUPACK,
 		linux.TCP_THIN_LINEAR_TIMEOUTS,
-		linux.TCP_TIMESTAMP,
-		linux.TCP_ULP:
+		linux.TCP_TIMESTAMP:
+
+		t.Kernel().EmitUnimplementedEvent(t)
+	}
+}
+
+// emitUnimplementedEventUDP emits unimplemented event if name is valid. It
+// contains names that are common between Get and SetSockOpt when level is
+// SOL_UDP.
+func emitUnimplementedEventUDP(t *kernel.Task, name int) {
+	switch name {
+	case linux.UDP_CORK,
+		linux.UDP_ENCAP,
+		linux.UDP_NO_CHECK6_TX,
+		linux.UDP_NO_CHECK6_RX:
 
 		t.Kernel().EmitUnimplementedEvent(t)
 	}
@@ -2357,7 +3289,6 @@ func emitUnimplementedEventIPv6(t *kernel.Task, name int) {
 		linux.IPV6_AUTOFLOWLABEL,
 		linux.IPV6_DONTFRAG,
 		linux.IPV6_DSTOPTS,
-		linux.IPV6_FLOWINFO,
 		linux.IPV6_FLOWINFO_SEND,
 		linux.IPV6_FLOWLABEL_MGR,
 		linux.IPV6_FREEBIND,
@@ -2371,10 +3302,8 @@ func emitUnimplementedEventIPv6(t *kernel.Task, name int) {
 		linux.IPV6_MULTICAST_LOOP,
 		linux.IPV6_RECVDSTOPTS,
 		linux.IPV6_RECVFRAGSIZE,
-		linux.IPV6_RECVHOPLIMIT,
 		linux.IPV6_RECVHOPOPTS,
 		linux.IPV6_RECVPATHMTU,
-		linux.IPV6_RECVPKTINFO,
 		linux.IPV6_RECVRTHDR,
 		linux.IPV6_RTHDR,
 		linux.IPV6_RTHDRDSTOPTS,
@@ -2403,7 +3332,6 @@ func emitUnimplementedEventIP(t *kernel.Task, name int) {
 		linux.IP_PKTINFO,
 		linux.IP_PKTOPTIONS,
 		linux.IP_MTU_DISCOVER,
-		linux.IP_RECVTTL,
 		linux.IP_RECVTOS,
 		linux.IP_MTU,
 		linux.IP_FREEBIND,
@@ -2497,7 +3425,9 @@ func toLinuxPacketType(pktType tcpip.PacketType) uint8 {
 // nonBlockingRead issues a non-blocking read.
 //
 // TODO(b/78348848): Support timestamps for stream sockets.
-func (s *socketOpsCommon) nonBlockingRead(ctx context.Context, dst usermem.IOSequence, peek, trunc, senderRequested bool) (int, int, linux.SockAddr, uint32, socket.ControlMessages, *syserr.Error) {
+//
+// controlDataLen is the size of the buffer of the control messages.
+func (s *socketOpsCommon) nonBlockingRead(ctx context.Context, dst usermem.IOSequence, peek, trunc, senderRequested bool, controlDataLen uint64) (int, int, linux.SockAddr, uint32, socket.ControlMessages, *syserr.Error) {
 	isPacket := s.isPacketBased()
 
 	readOptions := tcpip.ReadOptions{
@@ -2526,6 +3456,11 @@ func (s *socketOpsCommon) nonBlockingRead(ctx context.Context, dst usermem.IOSeq
 	s.readMu.Lock()
 	defer s.readMu.Unlock()
 
+	if rx := s.tlsRX; rx != nil {
+		n, cmsg, err := s.tlsRead(rx, w, dst.NumBytes(), peek, controlDataLen)
+		return n, 0, nil, 0, cmsg, err
+	}
+
 	res, err := s.Endpoint.Read(w, readOptions)
 	if err == tcpip.ErrBadBuffer && dst.NumBytes() == 0 {
 		err = nil
@@ -2590,7 +3525,9 @@ func (s *socketOpsCommon) controlMessages(cm tcpip.ControlMessages) socket.Contr
 	readCM := socket.NewIPControlMessages(s.family, cm)
 	return socket.ControlMessages{
 		IP: socket.IPControlMessages{
-			HasTimestamp:       readCM.HasTimestamp && s.sockOptTimestamp,
+			HasTimestamp:       readCM.HasTimestamp && s.sockOptTimestamp && !s.sockOptTimestampNS,
+			HasTimestampNS:     readCM.HasTimestamp && s.sockOptTimestampNS,
+			HasTimestamping:    readCM.HasTimestamp && s.reportSoftwareTimestamps(linux.SOF_TIMESTAMPING_RX_SOFTWARE),
 			Timestamp:          readCM.Timestamp,
 			HasInq:             readCM.HasInq,
 			Inq:                readCM.Inq,
@@ -2600,12 +3537,89 @@ func (s *socketOpsCommon) controlMessages(cm tcpip.ControlMessages) socket.Contr
 			TClass:             readCM.TClass,
 			HasIPPacketInfo:    readCM.HasIPPacketInfo,
 			PacketInfo:         readCM.PacketInfo,
+			HasIPv6PacketInfo:  readCM.HasIPv6PacketInfo,
+			IPv6PacketInfo:     readCM.IPv6PacketInfo,
+			HasTTL:             readCM.HasTTL,
+			TTL:                readCM.TTL,
+			HasHopLimit:        readCM.HasHopLimit,
+			HopLimit:           readCM.HopLimit,
+			HasFlowInfo:        readCM.HasFlowInfo,
+			FlowInfo:           readCM.FlowInfo,
 			OriginalDstAddress: readCM.OriginalDstAddress,
 			SockErr:            readCM.SockErr,
+			HasGROSize:         readCM.HasGROSize,
+			GROSize:            readCM.GROSize,
 		},
 	}
 }
 
+// reportSoftwareTimestamps returns true if software timestamps should be
+// reported in SCM_TIMESTAMPING control messages for packets whose timestamps
+// were requested by generationFlag.
+//
+// Precondition: s.readMu must be locked.
+func (s *socketOpsCommon) reportSoftwareTimestamps(generationFlag uint32) bool {
+	want := generationFlag | linux.SOF_TIMESTAMPING_SOFTWARE
+	return s.sockOptTimestamping&want == want
+}
+
+// queueTxTimestamps queues software transmit timestamps on the error queue
+// for n bytes just accepted by the endpoint, if SO_TIMESTAMPING requested
+// them.
+//
+// The timestamps are taken when the data is handed to netstack, which records
+// both the scheduling and the send time of a packet. The looped back payload is
+// always omitted, as with SOF_TIMESTAMPING_OPT_TSONLY.
+func (s *socketOpsCommon) queueTxTimestamps(t *kernel.Task, n int64) {
+	if n == 0 {
+		return
+	}
+	s.readMu.Lock()
+	flags := s.sockOptTimestamping
+	if flags&(linux.SOF_TIMESTAMPING_TX_SCHED|linux.SOF_TIMESTAMPING_TX_SOFTWARE) == 0 {
+		s.readMu.Unlock()
+		return
+	}
+	var key uint32
+	if flags&linux.SOF_TIMESTAMPING_OPT_ID != 0 {
+		if s.isPacketBased() {
+			key = s.timestampingKey
+			s.timestampingKey++
+		} else {
+			// Stream sockets are keyed by the offset of the last byte.
+			s.timestampingKey += uint32(n)
+			key = s.timestampingKey - 1
+		}
+	}
+	s.readMu.Unlock()
+
+	netProto := header.IPv4ProtocolNumber
+	if s.family == linux.AF_INET6 {
+		netProto = header.IPv6ProtocolNumber
+	}
+	now := t.Kernel().RealtimeClock().Now().Nanoseconds()
+	so := s.Endpoint.SocketOptions()
+	for _, ts := range []struct {
+		flag uint32
+		info uint32
+	}{
+		{linux.SOF_TIMESTAMPING_TX_SCHED, linux.SCM_TSTAMP_SCHED},
+		{linux.SOF_TIMESTAMPING_TX_SOFTWARE, linux.SCM_TSTAMP_SND},
+	} {
+		if flags&ts.flag == 0 {
+			continue
+		}
+		so.QueueErr(&tcpip.SockError{
+			ErrOrigin: tcpip.SockExtErrorOriginTimestamping,
+			ErrInfo:   ts.info,
+			ErrData:   key,
+			Timestamp: now,
+			NetProto:  netProto,
+		})
+	}
+	s.Notify(waiter.EventErr)
+}
+
 // updateTimestamp sets the timestamp for SIOCGSTAMP. It should be called after
 // successfully writing packet data out to userspace.
 //
@@ -2668,6 +3682,12 @@ func (s *socketOpsCommon) recvErr(t *kernel.Task, dst usermem.IOSequence) (int,
 	// supplied via msg_name.  -- recvmsg(2)
 	dstAddr, dstAddrLen := socket.ConvertAddress(addrFamilyFromNetProto(sockErr.NetProto), sockErr.Dst)
 	cmgs := socket.ControlMessages{IP: socket.NewIPControlMessages(s.family, tcpip.ControlMessages{SockErr: sockErr})}
+	if sockErr.ErrOrigin == tcpip.SockExtErrorOriginTimestamping {
+		s.readMu.Lock()
+		cmgs.IP.HasTimestamping = s.sockOptTimestamping&linux.SOF_TIMESTAMPING_SOFTWARE != 0
+		s.readMu.Unlock()
+		cmgs.IP.Timestamp = sockErr.Timestamp
+	}
 	return n, msgFlags, dstAddr, dstAddrLen, cmgs, syserr.FromError(err)
 }
 
@@ -2686,7 +3706,20 @@ func (s *socketOpsCommon) RecvMsg(t *kernel.Task, dst usermem.IOSequence, flags
 		// Stream sockets ignore the sender address.
 		senderRequested = false
 	}
-	n, msgFlags, senderAddr, senderAddrLen, controlMessages, err = s.nonBlockingRead(t, dst, peek, trunc, senderRequested)
+	n, msgFlags, senderAddr, senderAddrLen, controlMessages, err = s.nonBlockingRead(t, dst, peek, trunc, senderRequested, controlDataLen)
+
+	if err == syserr.ErrWouldBlock && !dontWait {
+		// With SO_BUSY_POLL, spin for the configured time before going to
+		// sleep, trading CPU for wakeup latency.
+		if usec := s.Endpoint.SocketOptions().GetBusyPoll(); usec > 0 {
+			clock := t.Kernel().MonotonicClock()
+			end := clock.Now().Add(time.Duration(usec) * time.Microsecond)
+			for err == syserr.ErrWouldBlock && clock.Now().Before(end) {
+				runtime.Gosched()
+				n, msgFlags, senderAddr, senderAddrLen, controlMessages, err = s.nonBlockingRead(t, dst, peek, trunc, senderRequested, controlDataLen)
+			}
+		}
+	}
 
 	if s.isPacketBased() && err == syserr.ErrClosedForReceive && flags&linux.MSG_DONTWAIT != 0 {
 		// In this situation we should return EAGAIN.
@@ -2714,7 +3747,7 @@ func (s *socketOpsCommon) RecvMsg(t *kernel.Task, dst usermem.IOSequence, flags
 
 	for {
 		var rn int
-		rn, msgFlags, senderAddr, senderAddrLen, controlMessages, err = s.nonBlockingRead(t, dst, peek, trunc, senderRequested)
+		rn, msgFlags, senderAddr, senderAddrLen, controlMessages, err = s.nonBlockingRead(t, dst, peek, trunc, senderRequested, controlDataLen)
 		n += rn
 		if err != nil && err != syserr.ErrWouldBlock {
 			// Always stop on errors other than would block as we generally
@@ -2743,6 +3776,35 @@ func (s *socketOpsCommon) RecvMsg(t *kernel.Task, dst usermem.IOSequence, flags
 	}
 }
 
+// queueZeroCopyCompletion reports the completion of a MSG_ZEROCOPY send of n
+// bytes just accepted by the endpoint on the error queue.
+//
+// Only the semantics of MSG_ZEROCOPY are implemented: the payload is still
+// copied out of the task's memory into the buffers that are handed down to the
+// link endpoint, so the send has completed as soon as it is accepted and it is
+// always reported as SO_EE_CODE_ZEROCOPY_COPIED. As in Linux, sends that
+// transfer no data are not numbered.
+//
+// TODO: Pin the task's pages and hand them down to the link endpoint, and
+// report their completion once the endpoint releases them, so that
+// MSG_ZEROCOPY actually saves the copy.
+func (s *socketOpsCommon) queueZeroCopyCompletion(n int64) {
+	if n == 0 {
+		return
+	}
+	s.readMu.Lock()
+	key := s.zeroCopyKey
+	s.zeroCopyKey++
+	s.readMu.Unlock()
+
+	netProto := header.IPv4ProtocolNumber
+	if s.family == linux.AF_INET6 {
+		netProto = header.IPv6ProtocolNumber
+	}
+	s.Endpoint.SocketOptions().QueueZeroCopyCompletion(key, key, linux.SO_EE_CODE_ZEROCOPY_COPIED, netProto)
+	s.Notify(waiter.EventErr)
+}
+
 // SendMsg implements the linux syscall sendmsg(2) for sockets backed by
 // tcpip.Endpoint.
 func (s *socketOpsCommon) SendMsg(t *kernel.Task, src usermem.IOSequence, to []byte, flags int, haveDeadline bool, deadline ktime.Time, controlMessages socket.ControlMessages) (int, *syserr.Error) {
@@ -2769,6 +3831,15 @@ func (s *socketOpsCommon) SendMsg(t *kernel.Task, src usermem.IOSequence, to []b
 		To:          addr,
 		More:        flags&linux.MSG_MORE != 0,
 		EndOfRecord: flags&linux.MSG_EOR != 0,
+		FastOpen:    flags&linux.MSG_FASTOPEN != 0,
+	}
+	if controlMessages.IP.HasGSOSize {
+		opts.GSOSize = controlMessages.IP.GSOSize
+	}
+	// With kTLS, TLS_SET_RECORD_TYPE sets the type of the records sent.
+	recordType := uint8(tlsRecordTypeApplicationData)
+	if controlMessages.IP.HasTLSRecordType {
+		recordType = controlMessages.IP.TLSRecordType
 	}
 
 	r := src.Reader(t)
@@ -2777,8 +3848,16 @@ func (s *socketOpsCommon) SendMsg(t *kernel.Task, src usermem.IOSequence, to []b
 		entry waiter.Entry
 		ch    <-chan struct{}
 	)
+	// MSG_ZEROCOPY is ignored unless SO_ZEROCOPY is enabled.
+	zeroCopy := flags&linux.MSG_ZEROCOPY != 0 && s.Endpoint.SocketOptions().GetZeroCopy()
+	defer func() {
+		s.queueTxTimestamps(t, total)
+		if zeroCopy {
+			s.queueZeroCopyCompletion(total)
+		}
+	}()
 	for {
-		n, err := s.Endpoint.Write(r, opts)
+		n, err := s.tlsWrite(r, opts, recordType)
 		total += n
 		if flags&linux.MSG_DONTWAIT != 0 {
 			return int(total), syserr.TranslateNetstackError(err)
@@ -2789,7 +3868,11 @@ func (s *socketOpsCommon) SendMsg(t *kernel.Task, src usermem.IOSequence, to []b
 				break
 			}
 			fallthrough
-		case tcpip.ErrWouldBlock:
+		case tcpip.ErrWouldBlock, tcpip.ErrConnectStarted:
+			// ErrConnectStarted is returned by a MSG_FASTOPEN write
+			// that had to start the handshake without sending any
+			// data; the data is sent once the connection is
+			// established.
 			if ch == nil {
 				// We'll have to block. Register for notification and keep trying to
 				// send all the data.
@@ -79,12 +79,20 @@ func getTransportProtocol(ctx context.Context, stype linux.SockType, protocol in
 			return header.UDPProtocolNumber, true, nil
 		case syscall.IPPROTO_TCP:
 			return header.TCPProtocolNumber, true, nil
+		case syscall.IPPROTO_IGMP:
+			return header.IGMPProtocolNumber, true, nil
 		// IPPROTO_RAW signifies that the raw socket isn't assigned to
 		// a transport protocol. Users will be able to write packets'
 		// IP headers and won't receive anything.
 		case syscall.IPPROTO_RAW:
 			return tcpip.TransportProtocolNumber(0), false, nil
 		}
+
+		// Any other protocol carried over IP may be used, even if netstack
+		// doesn't implement it (e.g. SCTP, GRE or OSPF).
+		if protocol > 0 && protocol < syscall.IPPROTO_RAW {
+			return tcpip.TransportProtocolNumber(protocol), true, nil
+		}
 	}
 	return 0, true, syserr.ErrProtocolNotSupported
 }
@@ -16,6 +16,8 @@ package netstack
 
 import (
 	"fmt"
+	"net"
+	"sort"
 
 	"gvisor.dev/gvisor/pkg/abi/linux"
 	"gvisor.dev/gvisor/pkg/log"
@@ -24,10 +26,15 @@ import (
 	"gvisor.dev/gvisor/pkg/syserror"
 	"gvisor.dev/gvisor/pkg/tcpip"
 	"gvisor.dev/gvisor/pkg/tcpip/header"
+	"gvisor.dev/gvisor/pkg/tcpip/link/qdisc/fq"
+	"gvisor.dev/gvisor/pkg/tcpip/link/qdisc/netem"
+	"gvisor.dev/gvisor/pkg/tcpip/link/qdisc/tbf"
+	"gvisor.dev/gvisor/pkg/tcpip/link/tunnel"
 	"gvisor.dev/gvisor/pkg/tcpip/network/ipv4"
 	"gvisor.dev/gvisor/pkg/tcpip/network/ipv6"
 	"gvisor.dev/gvisor/pkg/tcpip/stack"
 	"gvisor.dev/gvisor/pkg/tcpip/transport/tcp"
+	"gvisor.dev/gvisor/pkg/tcpip/transport/tcpconntrack"
 )
 
 // Stack implements inet.Stack for netstack/tcpip/stack.Stack.
@@ -60,17 +67,98 @@ func toLinuxARPHardwareType(t header.ARPHardwareType) uint16 {
 func (s *Stack) Interfaces() map[int32]inet.Interface {
 	is := make(map[int32]inet.Interface)
 	for id, ni := range s.Stack.NICInfo() {
-		is[int32(id)] = inet.Interface{
+		iface := inet.Interface{
 			Name:       ni.Name,
 			Addr:       []byte(ni.LinkAddress),
 			Flags:      uint32(nicStateFlagsToLinux(ni.Flags)),
 			DeviceType: toLinuxARPHardwareType(ni.ARPHardwareType),
 			MTU:        ni.MTU,
 		}
+		if tun, ok := s.Stack.GetLinkEndpointByName(ni.Name).(*tunnel.Endpoint); ok && ni.Name != "" {
+			iface.Tunnel = &inet.Tunnel{
+				Kind:     tun.Kind().String(),
+				VNI:      tun.VNI(),
+				Remote:   []byte(tun.Remote()),
+				Local:    []byte(tun.Local()),
+				Port:     tun.Port(),
+				Link:     int32(tun.NIC()),
+				Learning: tun.Learning(),
+			}
+		}
+		is[int32(id)] = iface
 	}
 	return is
 }
 
+// AddInterface implements inet.Stack.AddInterface.
+func (s *Stack) AddInterface(iface inet.Interface) (int32, error) {
+	if iface.Tunnel == nil {
+		return 0, syserror.EOPNOTSUPP
+	}
+	var kind tunnel.Kind
+	switch iface.Tunnel.Kind {
+	case tunnel.VXLAN.String():
+		kind = tunnel.VXLAN
+	case tunnel.GENEVE.String():
+		kind = tunnel.GENEVE
+	default:
+		return 0, syserror.EOPNOTSUPP
+	}
+
+	// New interfaces get the index following the highest one in use.
+	nics := s.Stack.NICInfo()
+	var id tcpip.NICID
+	for nicID, ni := range nics {
+		if iface.Name != "" && ni.Name == iface.Name {
+			return 0, syserror.EEXIST
+		}
+		if nicID > id {
+			id = nicID
+		}
+	}
+	id++
+	name := iface.Name
+	if name == "" {
+		name = fmt.Sprintf("%s%d", iface.Tunnel.Kind, id)
+	}
+
+	ep, err := tunnel.New(s.Stack, tunnel.Options{
+		Kind:        kind,
+		VNI:         iface.Tunnel.VNI,
+		Remote:      tcpip.Address(iface.Tunnel.Remote),
+		Local:       tcpip.Address(iface.Tunnel.Local),
+		Port:        iface.Tunnel.Port,
+		NIC:         tcpip.NICID(iface.Tunnel.Link),
+		LinkAddress: tcpip.LinkAddress(iface.Addr),
+		MTU:         iface.MTU,
+		Learning:    iface.Tunnel.Learning,
+	})
+	if err != nil {
+		return 0, syserr.TranslateNetstackError(err).ToError()
+	}
+	// As on Linux, new interfaces are down until they are brought up.
+	if err := s.Stack.CreateNICWithOptions(id, ep, stack.NICOptions{Name: name, Disabled: true}); err != nil {
+		ep.Close()
+		if err == tcpip.ErrDuplicateNICID {
+			return 0, syserror.EEXIST
+		}
+		return 0, syserr.TranslateNetstackError(err).ToError()
+	}
+	return int32(id), nil
+}
+
+// RemoveInterface implements inet.Stack.RemoveInterface.
+func (s *Stack) RemoveInterface(idx int32) error {
+	ni, ok := s.Stack.NICInfo()[tcpip.NICID(idx)]
+	if !ok {
+		return syserror.ENODEV
+	}
+	if _, ok := s.Stack.GetLinkEndpointByName(ni.Name).(*tunnel.Endpoint); !ok || ni.Name == "" {
+		return syserror.EOPNOTSUPP
+	}
+	return syserr.TranslateNetstackError(s.Stack.RemoveNIC(tcpip.NICID(idx))).ToError()
+}
+
 // InterfaceAddrs implements inet.Stack.InterfaceAddrs.
 func (s *Stack) InterfaceAddrs() map[int32][]inet.InterfaceAddr {
 	nicAddrs := make(map[int32][]inet.InterfaceAddr)
@@ -198,6 +286,124 @@ func (s *Stack) RemoveInterfaceAddr(idx int32, addr inet.InterfaceAddr) error {
 	return nil
 }
 
+// SetInterfaceMTU implements inet.Stack.SetInterfaceMTU.
+func (s *Stack) SetInterfaceMTU(idx int32, mtu uint32) error {
+	return syserr.TranslateNetstackError(s.Stack.SetNICMTU(tcpip.NICID(idx), mtu)).ToError()
+}
+
+// SetInterfaceUp implements inet.Stack.SetInterfaceUp.
+func (s *Stack) SetInterfaceUp(idx int32, up bool) error {
+	nicID := tcpip.NICID(idx)
+	if up {
+		return syserr.TranslateNetstackError(s.Stack.EnableNIC(nicID)).ToError()
+	}
+	return syserr.TranslateNetstackError(s.Stack.DisableNIC(nicID)).ToError()
+}
+
+// toLinuxNeighborState converts a netstack neighbor state to the equivalent
+// Linux NUD_* constant.
+func toLinuxNeighborState(state stack.NeighborState) uint16 {
+	switch state {
+	case stack.Incomplete:
+		return linux.NUD_INCOMPLETE
+	case stack.Reachable:
+		return linux.NUD_REACHABLE
+	case stack.Stale:
+		return linux.NUD_STALE
+	case stack.Delay:
+		return linux.NUD_DELAY
+	case stack.Probe:
+		return linux.NUD_PROBE
+	case stack.Static:
+		return linux.NUD_PERMANENT
+	case stack.Failed:
+		return linux.NUD_FAILED
+	default:
+		return linux.NUD_NONE
+	}
+}
+
+// Neighbors implements inet.Stack.Neighbors.
+func (s *Stack) Neighbors() map[int32][]inet.Neighbor {
+	nicNeighbors := make(map[int32][]inet.Neighbor)
+	for id := range s.Stack.NICInfo() {
+		entries, err := s.Stack.Neighbors(id)
+		if err != nil {
+			// The NIC does not resolve link addresses.
+			continue
+		}
+
+		var neighbors []inet.Neighbor
+		for _, e := range entries {
+			var family uint8
+			switch len(e.Addr) {
+			case header.IPv4AddressSize:
+				family = linux.AF_INET
+			case header.IPv6AddressSize:
+				family = linux.AF_INET6
+			default:
+				log.Warningf("Unknown network protocol in neighbor entry %+v", e)
+				continue
+			}
+
+			neighbors = append(neighbors, inet.Neighbor{
+				Family:   family,
+				State:    toLinuxNeighborState(e.State),
+				Addr:     []byte(e.Addr),
+				LinkAddr: []byte(e.LinkAddr),
+			})
+		}
+		nicNeighbors[int32(id)] = neighbors
+	}
+	return nicNeighbors
+}
+
+// convertNeighborAddr validates the address of a neighbor entry.
+func convertNeighborAddr(neigh inet.Neighbor) (tcpip.Address, error) {
+	switch neigh.Family {
+	case linux.AF_INET:
+		if len(neigh.Addr) != header.IPv4AddressSize {
+			return "", syserror.EINVAL
+		}
+	case linux.AF_INET6:
+		if len(neigh.Addr) != header.IPv6AddressSize {
+			return "", syserror.EINVAL
+		}
+	default:
+		return "", syserror.EAFNOSUPPORT
+	}
+	return tcpip.Address(neigh.Addr), nil
+}
+
+// AddNeighbor implements inet.Stack.AddNeighbor.
+func (s *Stack) AddNeighbor(idx int32, neigh inet.Neighbor) error {
+	addr, err := convertNeighborAddr(neigh)
+	if err != nil {
+		return err
+	}
+	if len(neigh.LinkAddr) == 0 {
+		return syserror.EINVAL
+	}
+	return syserr.TranslateNetstackError(s.Stack.AddStaticNeighbor(tcpip.NICID(idx), addr, tcpip.LinkAddress(neigh.LinkAddr))).ToError()
+}
+
+// RemoveNeighbor implements inet.Stack.RemoveNeighbor.
+func (s *Stack) RemoveNeighbor(idx int32, neigh inet.Neighbor) error {
+	addr, err := convertNeighborAddr(neigh)
+	if err != nil {
+		return err
+	}
+	switch err := s.Stack.RemoveNeighbor(tcpip.NICID(idx), addr); err {
+	case nil:
+		return nil
+	case tcpip.ErrBadAddress:
+		// There is no entry for the address.
+		return syserror.ENOENT
+	default:
+		return syserr.TranslateNetstackError(err).ToError()
+	}
+}
+
 // TCPReceiveBufferSize implements inet.Stack.TCPReceiveBufferSize.
 func (s *Stack) TCPReceiveBufferSize() (inet.TCPBufferSize, error) {
 	var rs tcpip.TCPReceiveBufferSizeRangeOption
@@ -268,6 +474,75 @@ func (s *Stack) SetTCPRecovery(recovery inet.TCPLossRecovery) error {
 	return syserr.TranslateNetstackError(s.Stack.SetTransportProtocolOption(tcp.ProtocolNumber, &opt)).ToError()
 }
 
+// TCPECN implements inet.Stack.TCPECN.
+func (s *Stack) TCPECN() (inet.TCPECNMode, error) {
+	var ecn tcpip.TCPECNOption
+	if err := s.Stack.TransportProtocolOption(tcp.ProtocolNumber, &ecn); err != nil {
+		return 0, syserr.TranslateNetstackError(err).ToError()
+	}
+	return inet.TCPECNMode(ecn), nil
+}
+
+// SetTCPECN implements inet.Stack.SetTCPECN.
+func (s *Stack) SetTCPECN(mode inet.TCPECNMode) error {
+	opt := tcpip.TCPECNOption(mode)
+	return syserr.TranslateNetstackError(s.Stack.SetTransportProtocolOption(tcp.ProtocolNumber, &opt)).ToError()
+}
+
+// TCPECNFallback implements inet.Stack.TCPECNFallback.
+func (s *Stack) TCPECNFallback() (bool, error) {
+	var fallback tcpip.TCPECNFallbackOption
+	err := s.Stack.TransportProtocolOption(tcp.ProtocolNumber, &fallback)
+	return bool(fallback), syserr.TranslateNetstackError(err).ToError()
+}
+
+// SetTCPECNFallback implements inet.Stack.SetTCPECNFallback.
+func (s *Stack) SetTCPECNFallback(enabled bool) error {
+	opt := tcpip.TCPECNFallbackOption(enabled)
+	return syserr.TranslateNetstackError(s.Stack.SetTransportProtocolOption(tcp.ProtocolNumber, &opt)).ToError()
+}
+
+// TCPSynCookies implements inet.Stack.TCPSynCookies.
+func (s *Stack) TCPSynCookies() (inet.TCPSynCookiesMode, error) {
+	var cookies tcpip.TCPSynCookiesOption
+	if err := s.Stack.TransportProtocolOption(tcp.ProtocolNumber, &cookies); err != nil {
+		return 0, syserr.TranslateNetstackError(err).ToError()
+	}
+	return inet.TCPSynCookiesMode(cookies), nil
+}
+
+// SetTCPSynCookies implements inet.Stack.SetTCPSynCookies.
+func (s *Stack) SetTCPSynCookies(mode inet.TCPSynCookiesMode) error {
+	opt := tcpip.TCPSynCookiesOption(mode)
+	return syserr.TranslateNetstackError(s.Stack.SetTransportProtocolOption(tcp.ProtocolNumber, &opt)).ToError()
+}
+
+// TCPAbortOnOverflow implements inet.Stack.TCPAbortOnOverflow.
+func (s *Stack) TCPAbortOnOverflow() (bool, error) {
+	var abort tcpip.TCPAbortOnOverflowOption
+	err := s.Stack.TransportProtocolOption(tcp.ProtocolNumber, &abort)
+	return bool(abort), syserr.TranslateNetstackError(err).ToError()
+}
+
+// SetTCPAbortOnOverflow implements inet.Stack.SetTCPAbortOnOverflow.
+func (s *Stack) SetTCPAbortOnOverflow(enabled bool) error {
+	opt := tcpip.TCPAbortOnOverflowOption(enabled)
+	return syserr.TranslateNetstackError(s.Stack.SetTransportProtocolOption(tcp.ProtocolNumber, &opt)).ToError()
+}
+
+// TCPMigrateReq implements inet.Stack.TCPMigrateReq.
+func (s *Stack) TCPMigrateReq() (bool, error) {
+	var migrate tcpip.TCPMigrateReqOption
+	err := s.Stack.TransportProtocolOption(tcp.ProtocolNumber, &migrate)
+	return bool(migrate), syserr.TranslateNetstackError(err).ToError()
+}
+
+// SetTCPMigrateReq implements inet.Stack.SetTCPMigrateReq.
+func (s *Stack) SetTCPMigrateReq(enabled bool) error {
+	opt := tcpip.TCPMigrateReqOption(enabled)
+	return syserr.TranslateNetstackError(s.Stack.SetTransportProtocolOption(tcp.ProtocolNumber, &opt)).ToError()
+}
+
 // Statistics implements inet.Stack.Statistics.
 func (s *Stack) Statistics(stat interface{}, arg string) error {
 	switch stats := stat.(type) {
@@ -423,6 +698,7 @@ func (s *Stack) RouteTable() []inet.Route {
 			// TODO(gvisor.dev/issue/595): Set scope for routes.
 			Scope: linux.RT_SCOPE_LINK,
 			Type:  linux.RTN_UNICAST,
+			Table: toLinuxRouteTable(rt.Table),
 
 			DstAddr:         []byte(rt.Destination.ID()),
 			OutputInterface: int32(rt.NIC),
@@ -433,6 +709,167 @@ func (s *Stack) RouteTable() []inet.Route {
 	return routeTable
 }
 
+// toLinuxRouteTable converts a netstack routing table ID to a Linux one.
+func toLinuxRouteTable(id tcpip.RouteTableID) uint32 {
+	if id == tcpip.MainRouteTable {
+		return linux.RT_TABLE_MAIN
+	}
+	return uint32(id)
+}
+
+// fromLinuxRouteTable converts a Linux routing table ID to a netstack one. The
+// unspecified table is the main table.
+func fromLinuxRouteTable(table uint32) tcpip.RouteTableID {
+	if table == linux.RT_TABLE_UNSPEC || table == linux.RT_TABLE_MAIN {
+		return tcpip.MainRouteTable
+	}
+	return tcpip.RouteTableID(table)
+}
+
+// familyAddrSize returns the network protocol and address size of an address
+// family.
+func familyAddrSize(family uint8) (tcpip.NetworkProtocolNumber, int, error) {
+	switch family {
+	case linux.AF_INET:
+		return ipv4.ProtocolNumber, header.IPv4AddressSize, nil
+	case linux.AF_INET6:
+		return ipv6.ProtocolNumber, header.IPv6AddressSize, nil
+	default:
+		return 0, 0, syserror.EAFNOSUPPORT
+	}
+}
+
+// convertPrefix converts an address prefix to a tcpip.Subnet of addresses of
+// addrSize bytes. An empty address is the unspecified address.
+func convertPrefix(addr []byte, prefixLen uint8, addrSize int) (tcpip.Subnet, error) {
+	if int(prefixLen) > addrSize*8 {
+		return tcpip.Subnet{}, syserror.EINVAL
+	}
+	switch len(addr) {
+	case 0:
+		if prefixLen != 0 {
+			return tcpip.Subnet{}, syserror.EINVAL
+		}
+		addr = make([]byte, addrSize)
+	case addrSize:
+	default:
+		return tcpip.Subnet{}, syserror.EINVAL
+	}
+
+	mask := tcpip.AddressMask(net.CIDRMask(int(prefixLen), addrSize*8))
+	subnet, err := tcpip.NewSubnet(tcpip.Address(addr), mask)
+	if err != nil {
+		// The address has bits set past the prefix length.
+		return tcpip.Subnet{}, syserror.EINVAL
+	}
+	return subnet, nil
+}
+
+// convertRoute converts the destination, gateway and table of an inet.Route to
+// a tcpip.Route. The NIC of the returned route is the output interface of
+// route, which may be zero.
+func convertRoute(route inet.Route) (tcpip.Route, error) {
+	_, addrSize, err := familyAddrSize(route.Family)
+	if err != nil {
+		return tcpip.Route{}, err
+	}
+	subnet, err := convertPrefix(route.DstAddr, route.DstLen, addrSize)
+	if err != nil {
+		return tcpip.Route{}, err
+	}
+	if len(route.GatewayAddr) != 0 && len(route.GatewayAddr) != addrSize {
+		return tcpip.Route{}, syserror.EINVAL
+	}
+
+	return tcpip.Route{
+		Destination: subnet,
+		Gateway:     tcpip.Address(route.GatewayAddr),
+		NIC:         tcpip.NICID(route.OutputInterface),
+		Table:       fromLinuxRouteTable(route.Table),
+	}, nil
+}
+
+// AddRoute implements inet.Stack.AddRoute.
+func (s *Stack) AddRoute(route inet.Route) error {
+	rt, err := convertRoute(route)
+	if err != nil {
+		return err
+	}
+
+	nics := s.Stack.NICInfo()
+	if rt.NIC == 0 {
+		// Use the NIC the gateway is directly reachable through, as Linux
+		// does.
+		if len(rt.Gateway) == 0 {
+			return syserror.ENODEV
+		}
+	nicLoop:
+		for id, ni := range nics {
+			for _, a := range ni.ProtocolAddresses {
+				if subnet := a.AddressWithPrefix.Subnet(); subnet.Contains(rt.Gateway) {
+					rt.NIC = id
+					break nicLoop
+				}
+			}
+		}
+		if rt.NIC == 0 {
+			return syserror.ENETUNREACH
+		}
+	} else if _, ok := nics[rt.NIC]; !ok {
+		return syserror.ENODEV
+	}
+
+	table := s.Stack.GetRouteTable()
+	for _, r := range table {
+		if r.Equal(rt) {
+			return syserror.EEXIST
+		}
+	}
+
+	// Routes are matched in order, so more specific routes are kept first.
+	i := len(table)
+	for j, r := range table {
+		if r.Destination.Prefix() < rt.Destination.Prefix() {
+			i = j
+			break
+		}
+	}
+	table = append(table, tcpip.Route{})
+	copy(table[i+1:], table[i:])
+	table[i] = rt
+	s.Stack.SetRouteTable(table)
+
+	return nil
+}
+
+// RemoveRoute implements inet.Stack.RemoveRoute.
+func (s *Stack) RemoveRoute(route inet.Route) error {
+	rt, err := convertRoute(route)
+	if err != nil {
+		return err
+	}
+
+	removed := false
+	s.Stack.RemoveRoutes(func(r tcpip.Route) bool {
+		if r.Destination != rt.Destination || r.Table != rt.Table {
+			return false
+		}
+		if len(rt.Gateway) != 0 && r.Gateway != rt.Gateway {
+			return false
+		}
+		if rt.NIC != 0 && r.NIC != rt.NIC {
+			return false
+		}
+		removed = true
+		return true
+	})
+	if !removed {
+		return syserror.ESRCH
+	}
+
+	return nil
+}
+
 // IPTables returns the stack's iptables.
 func (s *Stack) IPTables() (*stack.IPTables, error) {
 	return s.Stack.IPTables(), nil
@@ -478,3 +915,493 @@ func (s *Stack) SetForwarding(protocol tcpip.NetworkProtocolNumber, enable bool)
 	}
 	return nil
 }
+
+// RouteRules implements inet.Stack.RouteRules.
+func (s *Stack) RouteRules() []inet.Rule {
+	families := []uint8{linux.AF_INET}
+	if s.SupportsIPv6() {
+		families = append(families, linux.AF_INET6)
+	}
+
+	var rules []inet.Rule
+	for _, family := range families {
+		netProto, _, _ := familyAddrSize(family)
+		for _, r := range s.Stack.GetRoutingRules(netProto) {
+			rule := inet.Rule{
+				Family:          family,
+				DstLen:          uint8(r.Destination.Prefix()),
+				SrcLen:          uint8(r.Source.Prefix()),
+				Priority:        r.Priority,
+				Table:           toLinuxRouteTable(r.Table),
+				Mark:            r.Mark,
+				MarkMask:        r.MarkMask,
+				InputInterface:  int32(r.InputNIC),
+				OutputInterface: int32(r.OutputNIC),
+
+				SuppressPrefixLen: -1,
+			}
+			if r.SuppressPrefix {
+				rule.SuppressPrefixLen = int32(r.SuppressPrefixLength)
+			}
+			if rule.DstLen != 0 {
+				rule.DstAddr = []byte(r.Destination.ID())
+			}
+			if rule.SrcLen != 0 {
+				rule.SrcAddr = []byte(r.Source.ID())
+			}
+			if r.Invert {
+				rule.Flags |= linux.FIB_RULE_INVERT
+			}
+			rules = append(rules, rule)
+		}
+	}
+	return rules
+}
+
+// convertRule converts an inet.Rule to a stack.RoutingRule of the returned
+// network protocol.
+func convertRule(rule inet.Rule) (tcpip.NetworkProtocolNumber, stack.RoutingRule, error) {
+	netProto, addrSize, err := familyAddrSize(rule.Family)
+	if err != nil {
+		return 0, stack.RoutingRule{}, err
+	}
+	src, err := convertPrefix(rule.SrcAddr, rule.SrcLen, addrSize)
+	if err != nil {
+		return 0, stack.RoutingRule{}, err
+	}
+	dst, err := convertPrefix(rule.DstAddr, rule.DstLen, addrSize)
+	if err != nil {
+		return 0, stack.RoutingRule{}, err
+	}
+
+	rr := stack.RoutingRule{
+		Priority:  rule.Priority,
+		Mark:      rule.Mark,
+		MarkMask:  rule.MarkMask,
+		InputNIC:  tcpip.NICID(rule.InputInterface),
+		OutputNIC: tcpip.NICID(rule.OutputInterface),
+		Invert:    rule.Flags&linux.FIB_RULE_INVERT != 0,
+		Table:     fromLinuxRouteTable(rule.Table),
+	}
+	// Selectors matching any address are left unset so that rules compare
+	// equal to the ones of the stack, e.g. the default ones.
+	if src.Prefix() != 0 {
+		rr.Source = src
+	}
+	if dst.Prefix() != 0 {
+		rr.Destination = dst
+	}
+	if rule.SuppressPrefixLen >= 0 {
+		rr.SuppressPrefix = true
+		rr.SuppressPrefixLength = int(rule.SuppressPrefixLen)
+	}
+	return netProto, rr, nil
+}
+
+// AddRouteRule implements inet.Stack.AddRouteRule.
+func (s *Stack) AddRouteRule(rule inet.Rule) error {
+	netProto, rr, err := convertRule(rule)
+	if err != nil {
+		return err
+	}
+	if netProto == ipv6.ProtocolNumber && !s.SupportsIPv6() {
+		return syserror.EAFNOSUPPORT
+	}
+
+	s.Stack.SetRoutingRules(netProto, append(s.Stack.GetRoutingRules(netProto), rr))
+	return nil
+}
+
+// RemoveRouteRule implements inet.Stack.RemoveRouteRule.
+func (s *Stack) RemoveRouteRule(rule inet.Rule) error {
+	netProto, rr, err := convertRule(rule)
+	if err != nil {
+		return err
+	}
+
+	rules := s.Stack.GetRoutingRules(netProto)
+	for i, r := range rules {
+		if r == rr {
+			s.Stack.SetRoutingRules(netProto, append(rules[:i], rules[i+1:]...))
+			return nil
+		}
+	}
+	return syserror.ENOENT
+}
+
+// QueueingDisciplines implements inet.Stack.QueueingDisciplines.
+func (s *Stack) QueueingDisciplines() map[int32]inet.QueueingDiscipline {
+	qdiscs := make(map[int32]inet.QueueingDiscipline)
+	for id := range s.Stack.NICInfo() {
+		q, err := s.Stack.NICQueueingDiscipline(id)
+		if err != nil {
+			continue
+		}
+
+		var qdisc inet.QueueingDiscipline
+		switch q := q.(type) {
+		case *tbf.Qdisc:
+			opts := q.Options()
+			qdisc = inet.QueueingDiscipline{
+				Kind:  "tbf",
+				Limit: opts.Limit,
+				Rate:  opts.Rate,
+				Burst: opts.Burst,
+			}
+		case *netem.Qdisc:
+			opts := q.Options()
+			qdisc = inet.QueueingDiscipline{
+				Kind:      "netem",
+				Limit:     opts.Limit,
+				Latency:   opts.Latency,
+				Jitter:    opts.Jitter,
+				Loss:      opts.Loss,
+				Duplicate: opts.Duplicate,
+			}
+		case *fq.Qdisc:
+			opts := q.Options()
+			qdisc = inet.QueueingDiscipline{
+				Kind:      "fq",
+				Limit:     opts.Limit,
+				FlowLimit: opts.FlowLimit,
+				Quantum:   opts.Quantum,
+				Rate:      opts.MaxRate,
+			}
+		case *fq.CoDelQdisc:
+			opts := q.Options()
+			qdisc = inet.QueueingDiscipline{
+				Kind:     "fq_codel",
+				Limit:    opts.Limit,
+				Flows:    opts.Flows,
+				Quantum:  opts.Quantum,
+				Target:   opts.Target,
+				Interval: opts.Interval,
+			}
+		default:
+			// No qdisc.
+			continue
+		}
+		qdiscs[int32(id)] = qdisc
+	}
+	return qdiscs
+}
+
+// SetQueueingDiscipline implements inet.Stack.SetQueueingDiscipline.
+func (s *Stack) SetQueueingDiscipline(idx int32, qdisc *inet.QueueingDiscipline) error {
+	var newQdisc stack.NewQueueingDisciplineFunc
+	if qdisc != nil {
+		switch qdisc.Kind {
+		case "tbf":
+			if qdisc.Rate == 0 || qdisc.Burst == 0 {
+				return syserror.EINVAL
+			}
+			newQdisc = tbf.New(tbf.Options{
+				Rate:  qdisc.Rate,
+				Burst: qdisc.Burst,
+				Limit: qdisc.Limit,
+			})
+		case "netem":
+			if qdisc.Latency < 0 || qdisc.Jitter < 0 {
+				return syserror.EINVAL
+			}
+			newQdisc = netem.New(netem.Options{
+				Latency:   qdisc.Latency,
+				Jitter:    qdisc.Jitter,
+				Loss:      qdisc.Loss,
+				Duplicate: qdisc.Duplicate,
+				Limit:     qdisc.Limit,
+			})
+		case "fq":
+			if qdisc.Quantum == 0 {
+				return syserror.EINVAL
+			}
+			newQdisc = fq.New(fq.Options{
+				Limit:     qdisc.Limit,
+				FlowLimit: qdisc.FlowLimit,
+				Quantum:   qdisc.Quantum,
+				MaxRate:   qdisc.Rate,
+			})
+		case "fq_codel":
+			if qdisc.Quantum == 0 || qdisc.Flows == 0 || qdisc.Interval <= 0 || qdisc.Target < 0 {
+				return syserror.EINVAL
+			}
+			newQdisc = fq.NewCoDel(fq.CoDelOptions{
+				Limit:    qdisc.Limit,
+				Flows:    qdisc.Flows,
+				Quantum:  qdisc.Quantum,
+				Target:   qdisc.Target,
+				Interval: qdisc.Interval,
+			})
+		default:
+			// As in Linux, unknown kinds are not found.
+			return syserror.ENOENT
+		}
+	}
+	return syserr.TranslateNetstackError(s.Stack.SetNICQueueingDiscipline(tcpip.NICID(idx), newQdisc)).ToError()
+}
+
+// convertConnTrackTuple converts a tuple of connection tracking to an
+// inet.ConnTrackTuple.
+func convertConnTrackTuple(tuple stack.ConnTrackTuple) inet.ConnTrackTuple {
+	return inet.ConnTrackTuple{
+		SrcAddr: []byte(tuple.SrcAddr),
+		SrcPort: tuple.SrcPort,
+		DstAddr: []byte(tuple.DstAddr),
+		DstPort: tuple.DstPort,
+	}
+}
+
+// convertConnTrackEntry converts an entry of connection tracking to an
+// inet.ConnTrackEntry.
+func convertConnTrackEntry(entry *stack.ConnTrackEntry) inet.ConnTrackEntry {
+	family := uint8(linux.AF_INET)
+	if entry.NetProto == header.IPv6ProtocolNumber {
+		family = linux.AF_INET6
+	}
+
+	var state uint8
+	switch entry.TCPState {
+	case tcpconntrack.ResultConnecting:
+		state = linux.TCP_CONNTRACK_SYN_SENT
+	case tcpconntrack.ResultAlive:
+		state = linux.TCP_CONNTRACK_ESTABLISHED
+	case tcpconntrack.ResultClosedByPeer, tcpconntrack.ResultClosedBySelf:
+		state = linux.TCP_CONNTRACK_TIME_WAIT
+	case tcpconntrack.ResultReset:
+		state = linux.TCP_CONNTRACK_CLOSE
+	default:
+		state = linux.TCP_CONNTRACK_NONE
+	}
+
+	// Entries are only visible once confirmed, and connections are assured
+	// once both directions were seen past the handshake.
+	status := uint32(linux.IPS_CONFIRMED)
+	if entry.SeenReply {
+		status |= linux.IPS_SEEN_REPLY
+		if state != linux.TCP_CONNTRACK_SYN_SENT && state != linux.TCP_CONNTRACK_NONE {
+			status |= linux.IPS_ASSURED
+		}
+	}
+	if entry.DstNAT {
+		status |= linux.IPS_DST_NAT | linux.IPS_DST_NAT_DONE
+	}
+
+	return inet.ConnTrackEntry{
+		ID:       entry.ID,
+		Family:   family,
+		Protocol: uint8(entry.TransProto),
+		Original: convertConnTrackTuple(entry.Original),
+		Reply:    convertConnTrackTuple(entry.Reply),
+		TCPState: state,
+		Status:   status,
+		Timeout:  entry.Timeout,
+	}
+}
+
+// ConnTrackEntries implements inet.Stack.ConnTrackEntries.
+func (s *Stack) ConnTrackEntries() ([]inet.ConnTrackEntry, error) {
+	var entries []inet.ConnTrackEntry
+	for _, entry := range s.Stack.IPTables().ConnTrack().Entries() {
+		entries = append(entries, convertConnTrackEntry(&entry))
+	}
+	return entries, nil
+}
+
+// RemoveConnTrackEntries implements inet.Stack.RemoveConnTrackEntries.
+func (s *Stack) RemoveConnTrackEntries(match func(*inet.ConnTrackEntry) bool) (int, error) {
+	return s.Stack.IPTables().ConnTrack().DeleteEntries(func(entry *stack.ConnTrackEntry) bool {
+		e := convertConnTrackEntry(entry)
+		return match(&e)
+	}), nil
+}
+
+// ConnTrackSettings implements inet.Stack.ConnTrackSettings.
+func (s *Stack) ConnTrackSettings() (inet.ConnTrackSettings, error) {
+	settings := s.Stack.IPTables().ConnTrack().Settings()
+	return inet.ConnTrackSettings{
+		Max:                   uint32(settings.MaxConnections),
+		Buckets:               uint32(settings.Buckets),
+		TCPTimeoutSynSent:     settings.TCPSynSentTimeout,
+		TCPTimeoutEstablished: settings.TCPEstablishedTimeout,
+		TCPTimeoutTimeWait:    settings.TCPTimeWaitTimeout,
+		TCPTimeoutClose:       settings.TCPCloseTimeout,
+	}, nil
+}
+
+// SetConnTrackSettings implements inet.Stack.SetConnTrackSettings.
+func (s *Stack) SetConnTrackSettings(settings inet.ConnTrackSettings) error {
+	return syserr.TranslateNetstackError(s.Stack.IPTables().ConnTrack().SetSettings(stack.ConnTrackSettings{
+		Buckets:               int(settings.Buckets),
+		MaxConnections:        int(settings.Max),
+		TCPSynSentTimeout:     settings.TCPTimeoutSynSent,
+		TCPEstablishedTimeout: settings.TCPTimeoutEstablished,
+		TCPTimeWaitTimeout:    settings.TCPTimeoutTimeWait,
+		TCPCloseTimeout:       settings.TCPTimeoutClose,
+	})).ToError()
+}
+
+// ConnTrackStats implements inet.Stack.ConnTrackStats.
+func (s *Stack) ConnTrackStats() (inet.ConnTrackStats, error) {
+	stats := s.Stack.IPTables().ConnTrack().Stats()
+	return inet.ConnTrackStats{
+		Entries:      uint32(stats.Connections),
+		Found:        stats.Found,
+		Insert:       stats.Insert,
+		InsertFailed: stats.InsertFailed,
+		Drop:         stats.Drop,
+	}, nil
+}
+
+// AddressLabels implements inet.Stack.AddressLabels.
+func (s *Stack) AddressLabels() ([]inet.AddressLabel, error) {
+	if !s.SupportsIPv6() {
+		return nil, syserror.EAFNOSUPPORT
+	}
+	labels, err := s.Stack.AddressLabels(ipv6.ProtocolNumber)
+	if err != nil {
+		return nil, syserr.TranslateNetstackError(err).ToError()
+	}
+
+	ret := make([]inet.AddressLabel, 0, len(labels))
+	for _, l := range labels {
+		ret = append(ret, inet.AddressLabel{
+			Prefix:    []byte(l.Prefix.ID()),
+			PrefixLen: uint8(l.Prefix.Prefix()),
+			Interface: int32(l.NIC),
+			Label:     l.Label,
+		})
+	}
+	return ret, nil
+}
+
+// convertAddressLabel converts an inet.AddressLabel to a stack.AddressLabel.
+// As in Linux, the bits of the prefix past its length are ignored.
+func convertAddressLabel(label inet.AddressLabel) (stack.AddressLabel, error) {
+	if len(label.Prefix) != header.IPv6AddressSize || int(label.PrefixLen) > header.IPv6AddressSize*8 {
+		return stack.AddressLabel{}, syserror.EINVAL
+	}
+	return stack.AddressLabel{
+		Prefix: tcpip.AddressWithPrefix{
+			Address:   tcpip.Address(label.Prefix),
+			PrefixLen: int(label.PrefixLen),
+		}.Subnet(),
+		NIC:   tcpip.NICID(label.Interface),
+		Label: label.Label,
+	}, nil
+}
+
+// AddAddressLabel implements inet.Stack.AddAddressLabel.
+func (s *Stack) AddAddressLabel(label inet.AddressLabel, replace bool) error {
+	if !s.SupportsIPv6() {
+		return syserror.EAFNOSUPPORT
+	}
+	l, err := convertAddressLabel(label)
+	if err != nil {
+		return err
+	}
+
+	labels, tcpipErr := s.Stack.AddressLabels(ipv6.ProtocolNumber)
+	if tcpipErr != nil {
+		return syserr.TranslateNetstackError(tcpipErr).ToError()
+	}
+	found := false
+	for i, e := range labels {
+		if e.Prefix == l.Prefix && e.NIC == l.NIC {
+			if !replace {
+				return syserror.EEXIST
+			}
+			labels[i] = l
+			found = true
+			break
+		}
+	}
+	if !found {
+		labels = append(labels, l)
+	}
+	return syserr.TranslateNetstackError(s.Stack.SetAddressLabels(ipv6.ProtocolNumber, labels)).ToError()
+}
+
+// RemoveAddressLabel implements inet.Stack.RemoveAddressLabel.
+func (s *Stack) RemoveAddressLabel(label inet.AddressLabel) error {
+	if !s.SupportsIPv6() {
+		return syserror.EAFNOSUPPORT
+	}
+	l, err := convertAddressLabel(label)
+	if err != nil {
+		return err
+	}
+
+	labels, tcpipErr := s.Stack.AddressLabels(ipv6.ProtocolNumber)
+	if tcpipErr != nil {
+		return syserr.TranslateNetstackError(tcpipErr).ToError()
+	}
+	for i, e := range labels {
+		if e.Prefix == l.Prefix && e.NIC == l.NIC {
+			labels = append(labels[:i], labels[i+1:]...)
+			return syserr.TranslateNetstackError(s.Stack.SetAddressLabels(ipv6.ProtocolNumber, labels)).ToError()
+		}
+	}
+	return syserror.ESRCH
+}
+
+// ndpEndpoints returns the IPv6 endpoints of the NICs, ordered by NIC ID.
+func (s *Stack) ndpEndpoints() []ipv6.NDPEndpoint {
+	var ids []tcpip.NICID
+	for id := range s.Stack.NICInfo() {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+
+	var eps []ipv6.NDPEndpoint
+	for _, id := range ids {
+		ep, err := s.Stack.GetNetworkEndpoint(id, ipv6.ProtocolNumber)
+		if err != nil {
+			continue
+		}
+		if ndpEP, ok := ep.(ipv6.NDPEndpoint); ok {
+			eps = append(eps, ndpEP)
+		}
+	}
+	return eps
+}
+
+// IPv6TempAddrSettings implements inet.Stack.IPv6TempAddrSettings.
+func (s *Stack) IPv6TempAddrSettings() (inet.IPv6TempAddrSettings, error) {
+	// All interfaces have the same settings, report those of the first one.
+	eps := s.ndpEndpoints()
+	if len(eps) == 0 {
+		return inet.IPv6TempAddrSettings{}, syserror.EAFNOSUPPORT
+	}
+	c := eps[0].NDPConfigurations()
+
+	settings := inet.IPv6TempAddrSettings{
+		ValidLifetime:     c.MaxTempAddrValidLifetime,
+		PreferredLifetime: c.MaxTempAddrPreferredLifetime,
+	}
+	switch {
+	case !c.AutoGenTempGlobalAddresses:
+		settings.UseTempAddr = 0
+	case c.PreferPublicAddresses:
+		settings.UseTempAddr = 1
+	default:
+		settings.UseTempAddr = 2
+	}
+	return settings, nil
+}
+
+// SetIPv6TempAddrSettings implements inet.Stack.SetIPv6TempAddrSettings.
+func (s *Stack) SetIPv6TempAddrSettings(settings inet.IPv6TempAddrSettings) error {
+	eps := s.ndpEndpoints()
+	if len(eps) == 0 {
+		return syserror.EAFNOSUPPORT
+	}
+	for _, ep := range eps {
+		c := ep.NDPConfigurations()
+		c.AutoGenTempGlobalAddresses = settings.UseTempAddr > 0
+		c.PreferPublicAddresses = settings.UseTempAddr == 1
+		c.MaxTempAddrValidLifetime = settings.ValidLifetime
+		c.MaxTempAddrPreferredLifetime = settings.PreferredLifetime
+		ep.SetNDPConfigurations(c)
+	}
+	return nil
+}
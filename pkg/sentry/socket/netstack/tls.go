@@ -0,0 +1,706 @@
+// Copyright 2021 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package netstack
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"io"
+	"sync/atomic"
+
+	"golang.org/x/crypto/chacha20poly1305"
+	"gvisor.dev/gvisor/pkg/abi/linux"
+	"gvisor.dev/gvisor/pkg/binary"
+	"gvisor.dev/gvisor/pkg/marshal"
+	"gvisor.dev/gvisor/pkg/marshal/primitive"
+	"gvisor.dev/gvisor/pkg/sentry/socket"
+	"gvisor.dev/gvisor/pkg/sync"
+	"gvisor.dev/gvisor/pkg/syserr"
+	"gvisor.dev/gvisor/pkg/tcpip"
+	"gvisor.dev/gvisor/pkg/tcpip/transport/tcp"
+	"gvisor.dev/gvisor/pkg/usermem"
+	"gvisor.dev/gvisor/pkg/waiter"
+)
+
+// This file emulates the kernel TLS (kTLS) record layer of Linux, see
+// Documentation/networking/tls.rst. Once the "tls" upper layer protocol is set
+// on a TCP socket with TCP_ULP, the TLS_TX and TLS_RX options hand the keys
+// negotiated by the TLS handshake done in userspace to the socket: the data
+// written to it is then sent in encrypted TLS records, and the records received
+// are decrypted before being read.
+
+const (
+	// tlsULPName is the name of the kTLS upper layer protocol.
+	tlsULPName = "tls"
+
+	// tcpULPNameMax is TCP_ULP_NAME_MAX, the size of the name of upper
+	// layer protocols.
+	tcpULPNameMax = 16
+
+	// tlsHeaderSize is the size of the header of TLS records.
+	tlsHeaderSize = 5
+
+	// tlsMaxPlaintextSize is the maximum size of the data of TLS records.
+	tlsMaxPlaintextSize = 1 << 14
+
+	// tlsRecordTypeApplicationData is the type of the TLS records holding
+	// data, and of all the records encrypted with TLS 1.3.
+	tlsRecordTypeApplicationData = 23
+
+	// tlsLegacyVersion is the version in the header of TLS 1.2 and 1.3
+	// records.
+	tlsLegacyVersion = linux.TLS_1_2_VERSION
+)
+
+// tlsCipher is the state of one direction of a kTLS socket, as set with the
+// TLS_TX or TLS_RX socket option.
+//
+// +stateify savable
+type tlsCipher struct {
+	// mu serializes the writes of the records of TLS_TX. It protects seq,
+	// iv and pending.
+	mu sync.Mutex `state:"nosave"`
+
+	version    uint16
+	cipherType uint16
+	key        []byte
+
+	// salt is the implicit part of the nonces.
+	salt []byte
+
+	// iv is the explicit part of the nonces. With TLS 1.2 and AES-GCM, it
+	// is sent in the records and is incremented with each record, as Linux
+	// does.
+	iv []byte
+
+	// seq is the sequence number of the next record.
+	seq uint64
+
+	// pending is the part of the last record sent that wasn't written to
+	// the endpoint yet.
+	pending []byte
+
+	// aead is built from key on first use.
+	aead cipher.AEAD `state:"nosave"`
+}
+
+// newTLSCipher parses the value of the TLS_TX and TLS_RX socket options, a
+// struct tls12_crypto_info_* from uapi/linux/tls.h.
+func newTLSCipher(optVal []byte) (*tlsCipher, *syserr.Error) {
+	if len(optVal) < linux.SizeOfTLSCryptoInfo {
+		return nil, syserr.ErrInvalidArgument
+	}
+	c := tlsCipher{
+		version:    usermem.ByteOrder.Uint16(optVal),
+		cipherType: usermem.ByteOrder.Uint16(optVal[2:]),
+	}
+	if c.version != linux.TLS_1_2_VERSION && c.version != linux.TLS_1_3_VERSION {
+		return nil, syserr.ErrInvalidArgument
+	}
+
+	var ivSize, keySize, saltSize, seqSize int
+	switch c.cipherType {
+	case linux.TLS_CIPHER_AES_GCM_128:
+		ivSize = linux.TLS_CIPHER_AES_GCM_128_IV_SIZE
+		keySize = linux.TLS_CIPHER_AES_GCM_128_KEY_SIZE
+		saltSize = linux.TLS_CIPHER_AES_GCM_128_SALT_SIZE
+		seqSize = linux.TLS_CIPHER_AES_GCM_128_REC_SEQ_SIZE
+	case linux.TLS_CIPHER_AES_GCM_256:
+		ivSize = linux.TLS_CIPHER_AES_GCM_256_IV_SIZE
+		keySize = linux.TLS_CIPHER_AES_GCM_256_KEY_SIZE
+		saltSize = linux.TLS_CIPHER_AES_GCM_256_SALT_SIZE
+		seqSize = linux.TLS_CIPHER_AES_GCM_256_REC_SEQ_SIZE
+	case linux.TLS_CIPHER_CHACHA20_POLY1305:
+		ivSize = linux.TLS_CIPHER_CHACHA20_POLY1305_IV_SIZE
+		keySize = linux.TLS_CIPHER_CHACHA20_POLY1305_KEY_SIZE
+		saltSize = linux.TLS_CIPHER_CHACHA20_POLY1305_SALT_SIZE
+		seqSize = linux.TLS_CIPHER_CHACHA20_POLY1305_REC_SEQ_SIZE
+	default:
+		return nil, syserr.ErrInvalidArgument
+	}
+	if len(optVal) != linux.SizeOfTLSCryptoInfo+ivSize+keySize+saltSize+seqSize {
+		return nil, syserr.ErrInvalidArgument
+	}
+
+	// The parameters follow the header in this order.
+	b := optVal[linux.SizeOfTLSCryptoInfo:]
+	c.iv = append([]byte(nil), b[:ivSize]...)
+	b = b[ivSize:]
+	c.key = append([]byte(nil), b[:keySize]...)
+	b = b[keySize:]
+	c.salt = append([]byte(nil), b[:saltSize]...)
+	b = b[saltSize:]
+	c.seq = binary.BigEndian.Uint64(b[:seqSize])
+
+	if _, err := c.cipher(); err != nil {
+		return nil, syserr.ErrInvalidArgument
+	}
+	return &c, nil
+}
+
+// marshal returns the value of the TLS_TX or TLS_RX socket option, with the
+// current explicit nonce and sequence number.
+func (c *tlsCipher) marshal() []byte {
+	b := make([]byte, linux.SizeOfTLSCryptoInfo, linux.SizeOfTLSCryptoInfo+len(c.iv)+len(c.key)+len(c.salt)+8)
+	usermem.ByteOrder.PutUint16(b, c.version)
+	usermem.ByteOrder.PutUint16(b[2:], c.cipherType)
+	b = append(b, c.iv...)
+	b = append(b, c.key...)
+	b = append(b, c.salt...)
+	var seq [8]byte
+	binary.BigEndian.PutUint64(seq[:], c.seq)
+	return append(b, seq[:]...)
+}
+
+// cipher returns the AEAD of c.
+func (c *tlsCipher) cipher() (cipher.AEAD, error) {
+	if c.aead != nil {
+		return c.aead, nil
+	}
+	var err error
+	switch c.cipherType {
+	case linux.TLS_CIPHER_CHACHA20_POLY1305:
+		c.aead, err = chacha20poly1305.New(c.key)
+	default:
+		var block cipher.Block
+		if block, err = aes.NewCipher(c.key); err == nil {
+			c.aead, err = cipher.NewGCM(block)
+		}
+	}
+	return c.aead, err
+}
+
+// explicitNonceSize returns the size of the part of the nonce sent in records.
+func (c *tlsCipher) explicitNonceSize() int {
+	if c.version == linux.TLS_1_2_VERSION && c.cipherType != linux.TLS_CIPHER_CHACHA20_POLY1305 {
+		return len(c.iv)
+	}
+	return 0
+}
+
+// overhead returns the difference between the size of the payload of records
+// and the size of their data.
+func (c *tlsCipher) overhead(aead cipher.AEAD) int {
+	n := c.explicitNonceSize() + aead.Overhead()
+	if c.version == linux.TLS_1_3_VERSION {
+		// The record type is encrypted with the data.
+		n++
+	}
+	return n
+}
+
+// nonce returns the nonce of the next record.
+func (c *tlsCipher) nonce() []byte {
+	nonce := make([]byte, 0, len(c.salt)+len(c.iv))
+	nonce = append(nonce, c.salt...)
+	nonce = append(nonce, c.iv...)
+	if c.explicitNonceSize() == 0 {
+		// The sequence number is mixed with the IV instead. See RFC 8446
+		// section 5.3 and RFC 7905 section 2.
+		for i := 0; i < 8; i++ {
+			nonce[len(nonce)-8+i] ^= byte(c.seq >> (56 - 8*i))
+		}
+	}
+	return nonce
+}
+
+// additionalData returns the additional authenticated data of the TLS 1.2
+// record of type typ holding n bytes of data.
+func (c *tlsCipher) additionalData(typ uint8, n int) []byte {
+	ad := make([]byte, 13)
+	binary.BigEndian.PutUint64(ad, c.seq)
+	ad[8] = typ
+	binary.BigEndian.PutUint16(ad[9:], tlsLegacyVersion)
+	binary.BigEndian.PutUint16(ad[11:], uint16(n))
+	return ad
+}
+
+// advance moves to the next record.
+func (c *tlsCipher) advance() {
+	c.seq++
+	if c.explicitNonceSize() != 0 {
+		for i := len(c.iv) - 1; i >= 0; i-- {
+			c.iv[i]++
+			if c.iv[i] != 0 {
+				break
+			}
+		}
+	}
+}
+
+// seal returns the record of type typ holding data.
+func (c *tlsCipher) seal(aead cipher.AEAD, typ uint8, data []byte) []byte {
+	explicit := c.explicitNonceSize()
+	size := len(data) + c.overhead(aead)
+	record := make([]byte, tlsHeaderSize+explicit, tlsHeaderSize+size)
+	record[0] = typ
+	binary.BigEndian.PutUint16(record[1:], tlsLegacyVersion)
+	binary.BigEndian.PutUint16(record[3:], uint16(size))
+	copy(record[tlsHeaderSize:], c.iv[:explicit])
+
+	var ad []byte
+	if c.version == linux.TLS_1_3_VERSION {
+		// All TLS 1.3 records look like data, the real type being
+		// appended to the encrypted data.
+		record[0] = tlsRecordTypeApplicationData
+		data = append(append(make([]byte, 0, len(data)+1), data...), typ)
+		ad = record[:tlsHeaderSize]
+	} else {
+		ad = c.additionalData(typ, len(data))
+	}
+	record = aead.Seal(record, c.nonce(), data, ad)
+	c.advance()
+	return record
+}
+
+// open decrypts record, a complete record, and returns its type and data.
+func (c *tlsCipher) open(aead cipher.AEAD, record []byte) (uint8, []byte, *syserr.Error) {
+	typ := record[0]
+	payload := record[tlsHeaderSize:]
+	nonce := c.nonce()
+	explicit := c.explicitNonceSize()
+	copy(nonce[len(c.salt):], payload[:explicit])
+	payload = payload[explicit:]
+
+	var ad []byte
+	if c.version == linux.TLS_1_3_VERSION {
+		ad = record[:tlsHeaderSize]
+	} else {
+		ad = c.additionalData(typ, len(payload)-aead.Overhead())
+	}
+	data, err := aead.Open(payload[:0], nonce, payload, ad)
+	if err != nil {
+		return 0, nil, syserr.ErrInvalidDataMessage
+	}
+	if c.version == linux.TLS_1_3_VERSION {
+		// Remove the padding, and the real type that precedes it.
+		i := len(data) - 1
+		for i >= 0 && data[i] == 0 {
+			i--
+		}
+		if i < 0 {
+			return 0, nil, syserr.ErrInvalidDataMessage
+		}
+		typ = data[i]
+		data = data[:i]
+	}
+	c.advance()
+	return typ, data, nil
+}
+
+// write writes the data of p to ep in records of type typ. It returns the
+// number of bytes of p that were sent.
+//
+// Records are only made as large as the free space of the send buffer of ep,
+// so that they are usually written entirely: this relies on the caller
+// serializing the writes to ep.
+//
+// Precondition: c.mu must be locked. The rest of a record that couldn't be written
+// is kept in pending, and written before the next records.
+func (c *tlsCipher) write(ep tcpip.Endpoint, p tcpip.Payloader, opts tcpip.WriteOptions, typ uint8) (int64, *tcpip.Error) {
+	aead, err := c.cipher()
+	if err != nil {
+		panic("invalid kTLS key: " + err.Error())
+	}
+	if err := c.flush(ep, opts); err != nil {
+		return 0, err
+	}
+	var total int64
+	for p.Len() > 0 && len(c.pending) == 0 {
+		size, err := ep.GetSockOptInt(tcpip.SendBufferSizeOption)
+		if err != nil {
+			return total, err
+		}
+		used, err := ep.GetSockOptInt(tcpip.SendQueueSizeOption)
+		if err != nil {
+			return total, err
+		}
+		n := size - used - tlsHeaderSize - c.overhead(aead)
+		if n <= 0 {
+			if total == 0 {
+				return 0, tcpip.ErrWouldBlock
+			}
+			break
+		}
+		if n > tlsMaxPlaintextSize {
+			n = tlsMaxPlaintextSize
+		}
+		if l := p.Len(); n > l {
+			n = l
+		}
+
+		data := make([]byte, n)
+		if _, err := io.ReadFull(p, data); err != nil {
+			if total == 0 {
+				return 0, tcpip.ErrBadBuffer
+			}
+			break
+		}
+		c.pending = c.seal(aead, typ, data)
+		total += int64(n)
+		if err := c.flush(ep, opts); err != nil && err != tcpip.ErrWouldBlock {
+			// The endpoint can't be written to anymore, so the
+			// record is dropped with it.
+			return total, err
+		}
+	}
+	return total, nil
+}
+
+// flush writes c.pending to ep.
+func (c *tlsCipher) flush(ep tcpip.Endpoint, opts tcpip.WriteOptions) *tcpip.Error {
+	for len(c.pending) > 0 {
+		var r bytes.Reader
+		r.Reset(c.pending)
+		n, err := ep.Write(&r, opts)
+		c.pending = c.pending[n:]
+		if err != nil {
+			return err
+		}
+		if n == 0 {
+			return tcpip.ErrWouldBlock
+		}
+	}
+	c.pending = nil
+	return nil
+}
+
+// tlsReceiver is the state of the receive direction of a kTLS socket.
+//
+// +stateify savable
+type tlsReceiver struct {
+	cipher *tlsCipher
+
+	// record holds the part of the next record that was received.
+	record []byte
+
+	// data holds the data of the last record that was not read yet.
+	data []byte
+
+	// recordType is the type of the record holding data.
+	recordType uint8
+
+	// pending is 1 if data is not empty. It is accessed atomically.
+	pending uint32
+
+	// err is the error that broke the record layer, returned by all reads.
+	err *syserr.Error `state:"nosave"`
+}
+
+// sliceWriter is an io.Writer appending to a slice.
+type sliceWriter struct {
+	b *[]byte
+}
+
+// Write implements io.Writer.Write.
+func (w sliceWriter) Write(p []byte) (int, error) {
+	*w.b = append(*w.b, p...)
+	return len(p), nil
+}
+
+// fill reads the next record from ep and decrypts it.
+func (r *tlsReceiver) fill(ep tcpip.Endpoint) *syserr.Error {
+	aead, err := r.cipher.cipher()
+	if err != nil {
+		panic("invalid kTLS key: " + err.Error())
+	}
+	for {
+		need := tlsHeaderSize
+		if len(r.record) >= tlsHeaderSize {
+			if binary.BigEndian.Uint16(r.record[1:]) != tlsLegacyVersion {
+				r.err = syserr.ErrInvalidDataMessage
+				return r.err
+			}
+			size := int(binary.BigEndian.Uint16(r.record[3:]))
+			if size > tlsMaxPlaintextSize+r.cipher.overhead(aead) {
+				r.err = syserr.ErrMessageTooLong
+				return r.err
+			}
+			if size < r.cipher.overhead(aead) {
+				r.err = syserr.ErrInvalidDataMessage
+				return r.err
+			}
+			need += size
+		}
+		if len(r.record) == need {
+			break
+		}
+		if _, err := ep.Read(&tcpip.LimitedWriter{
+			W: sliceWriter{&r.record},
+			N: int64(need - len(r.record)),
+		}, tcpip.ReadOptions{}); err != nil {
+			return syserr.TranslateNetstackError(err)
+		}
+	}
+
+	typ, data, serr := r.cipher.open(aead, r.record)
+	r.record = nil
+	if serr != nil {
+		r.err = serr
+		return r.err
+	}
+	r.recordType = typ
+	r.data = data
+	r.updatePending()
+	return nil
+}
+
+// updatePending updates r.pending after r.data changed.
+func (r *tlsReceiver) updatePending() {
+	var v uint32
+	if len(r.data) != 0 {
+		v = 1
+	}
+	atomic.StoreUint32(&r.pending, v)
+}
+
+// read reads the data of the records received from ep to dst, up to count
+// bytes. It returns the number of bytes read and the type of the records they
+// come from: like Linux, data from records of different types isn't mixed and
+// records other than data are read alone. They can only be read if their type
+// can be reported, as specified by haveRecordType.
+func (r *tlsReceiver) read(ep tcpip.Endpoint, dst io.Writer, count int64, peek, haveRecordType bool) (int, uint8, *syserr.Error) {
+	if r.err != nil {
+		return 0, 0, r.err
+	}
+	var (
+		n   int
+		typ uint8
+	)
+	for int64(n) < count {
+		if len(r.data) == 0 {
+			if err := r.fill(ep); err != nil {
+				if n > 0 {
+					break
+				}
+				return 0, 0, err
+			}
+		}
+		if n == 0 {
+			typ = r.recordType
+			if typ != tlsRecordTypeApplicationData && !haveRecordType {
+				return 0, 0, syserr.ErrIO
+			}
+		} else if r.recordType != typ {
+			break
+		}
+
+		c := len(r.data)
+		if rem := count - int64(n); int64(c) > rem {
+			c = int(rem)
+		}
+		w, err := dst.Write(r.data[:c])
+		n += w
+		if !peek {
+			r.data = r.data[w:]
+			r.updatePending()
+		}
+		if err != nil || peek || typ != tlsRecordTypeApplicationData {
+			if n == 0 {
+				return 0, 0, syserr.ErrBadBuffer
+			}
+			break
+		}
+	}
+	return n, typ, nil
+}
+
+// tlsWrite writes the data of p to the endpoint, in records of type typ once
+// TLS_TX is set.
+func (s *socketOpsCommon) tlsWrite(p tcpip.Payloader, opts tcpip.WriteOptions, typ uint8) (int64, *tcpip.Error) {
+	s.tlsMu.Lock()
+	tx := s.tlsTX
+	s.tlsMu.Unlock()
+	if tx == nil {
+		return s.Endpoint.Write(p, opts)
+	}
+	// Writes are serialized, as required by tlsCipher.write.
+	tx.mu.Lock()
+	defer tx.mu.Unlock()
+	return tx.write(s.Endpoint, p, opts, typ)
+}
+
+// write writes the data of p to the endpoint, encrypted once TLS_TX is set.
+func (s *socketOpsCommon) write(p tcpip.Payloader, opts tcpip.WriteOptions) (int64, *tcpip.Error) {
+	return s.tlsWrite(p, opts, tlsRecordTypeApplicationData)
+}
+
+// tlsRead reads the data decrypted by the TLS_RX state rx to w, up to count
+// bytes.
+//
+// Precondition: s.readMu must be locked.
+func (s *socketOpsCommon) tlsRead(rx *tlsReceiver, w io.Writer, count int64, peek bool, controlDataLen uint64) (int, socket.ControlMessages, *syserr.Error) {
+	// The type of the records is reported in a control message, which
+	// must fit in the buffer for other records than data to be read.
+	haveRecordType := controlDataLen >= uint64(linux.SizeOfControlMessageHeader+linux.SizeOfControlMessageTLSRecordType)
+	n, typ, err := rx.read(s.Endpoint, w, count, peek, haveRecordType)
+	if err != nil {
+		return 0, socket.ControlMessages{}, err
+	}
+	if !peek && n != 0 {
+		s.Endpoint.ModerateRecvBuf(n)
+	}
+	return n, socket.ControlMessages{
+		IP: socket.IPControlMessages{
+			HasTLSRecordType: haveRecordType,
+			TLSRecordType:    typ,
+		},
+	}, nil
+}
+
+// tlsReadiness returns the events of mask that are ready because of data
+// decrypted but not read yet.
+func (s *socketOpsCommon) tlsReadiness(mask waiter.EventMask) waiter.EventMask {
+	if mask&waiter.EventIn == 0 {
+		return 0
+	}
+	s.tlsMu.Lock()
+	rx := s.tlsRX
+	s.tlsMu.Unlock()
+	if rx != nil && atomic.LoadUint32(&rx.pending) != 0 {
+		return waiter.EventIn
+	}
+	return 0
+}
+
+// isTLSOption returns true if level and name are TCP_ULP or one of the SOL_TLS
+// options, implemented by socketOpsCommon.
+func isTLSOption(level, name int) bool {
+	return level == linux.SOL_TCP && name == linux.TCP_ULP || level == linux.SOL_TLS
+}
+
+// getSockOptTLS implements GetSockOpt for TCP_ULP and the SOL_TLS options.
+func (s *socketOpsCommon) getSockOptTLS(level, name, outLen int) (marshal.Marshallable, *syserr.Error) {
+	if _, skType, skProto := s.Type(); !isTCPSocket(skType, skProto) {
+		return nil, syserr.ErrProtocolNotAvailable
+	}
+	// readMu protects the state of TLS_RX.
+	s.readMu.Lock()
+	defer s.readMu.Unlock()
+	s.tlsMu.Lock()
+	defer s.tlsMu.Unlock()
+
+	if level == linux.SOL_TCP {
+		// TCP_ULP returns the zero padded name of the upper layer
+		// protocol.
+		if outLen < 0 {
+			return nil, syserr.ErrInvalidArgument
+		}
+		if !s.tlsULP {
+			var b primitive.ByteSlice
+			return &b, nil
+		}
+		if outLen > tcpULPNameMax {
+			outLen = tcpULPNameMax
+		}
+		b := make(primitive.ByteSlice, tcpULPNameMax)
+		copy(b, tlsULPName)
+		b = b[:outLen]
+		return &b, nil
+	}
+
+	if !s.tlsULP {
+		return nil, syserr.ErrProtocolNotAvailable
+	}
+	var c *tlsCipher
+	switch name {
+	case linux.TLS_TX:
+		c = s.tlsTX
+	case linux.TLS_RX:
+		if s.tlsRX != nil {
+			c = s.tlsRX.cipher
+		}
+	default:
+		return nil, syserr.ErrProtocolNotAvailable
+	}
+	if outLen < linux.SizeOfTLSCryptoInfo {
+		return nil, syserr.ErrInvalidArgument
+	}
+	if c == nil {
+		return nil, syserr.ErrBusy
+	}
+	if c == s.tlsTX {
+		c.mu.Lock()
+	}
+	b := primitive.ByteSlice(c.marshal())
+	if c == s.tlsTX {
+		c.mu.Unlock()
+	}
+	if outLen == linux.SizeOfTLSCryptoInfo {
+		// Only the version and the cipher are requested.
+		b = b[:linux.SizeOfTLSCryptoInfo]
+	} else if outLen < len(b) {
+		return nil, syserr.ErrInvalidArgument
+	}
+	return &b, nil
+}
+
+// setSockOptTLS implements SetSockOpt for TCP_ULP and the SOL_TLS options.
+func (s *socketOpsCommon) setSockOptTLS(level, name int, optVal []byte) *syserr.Error {
+	if _, skType, skProto := s.Type(); !isTCPSocket(skType, skProto) {
+		return syserr.ErrProtocolNotAvailable
+	}
+
+	if level == linux.SOL_TCP {
+		// TCP_ULP selects the upper layer protocol by name.
+		if len(optVal) >= tcpULPNameMax {
+			optVal = optVal[:tcpULPNameMax-1]
+		}
+		if i := bytes.IndexByte(optVal, 0); i >= 0 {
+			optVal = optVal[:i]
+		}
+		if string(optVal) != tlsULPName {
+			return syserr.ErrNoFileOrDir
+		}
+		s.tlsMu.Lock()
+		defer s.tlsMu.Unlock()
+		if s.tlsULP {
+			return syserr.ErrExists
+		}
+		if tcp.EndpointState(s.Endpoint.State()) != tcp.StateEstablished {
+			return syserr.ErrNotConnected
+		}
+		s.tlsULP = true
+		return nil
+	}
+
+	switch name {
+	case linux.TLS_TX, linux.TLS_RX:
+	default:
+		return syserr.ErrProtocolNotAvailable
+	}
+	c, err := newTLSCipher(optVal)
+
+	s.readMu.Lock()
+	defer s.readMu.Unlock()
+	s.tlsMu.Lock()
+	defer s.tlsMu.Unlock()
+	if !s.tlsULP {
+		return syserr.ErrProtocolNotAvailable
+	}
+	if name == linux.TLS_TX && s.tlsTX != nil || name == linux.TLS_RX && s.tlsRX != nil {
+		return syserr.ErrBusy
+	}
+	if err != nil {
+		return err
+	}
+	if name == linux.TLS_TX {
+		s.tlsTX = c
+	} else {
+		s.tlsRX = &tlsReceiver{cipher: c}
+	}
+	return nil
+}
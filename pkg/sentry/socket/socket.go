@@ -56,6 +56,15 @@ func packetInfoToLinux(packetInfo tcpip.IPPacketInfo) linux.ControlMessageIPPack
 	return p
 }
 
+// ipv6PacketInfoToLinux converts IPv6PacketInfo from tcpip format to Linux
+// format.
+func ipv6PacketInfoToLinux(packetInfo tcpip.IPv6PacketInfo) linux.ControlMessageIPv6PacketInfo {
+	var p linux.ControlMessageIPv6PacketInfo
+	copy(p.Addr[:], []byte(packetInfo.Addr))
+	p.NIC = uint32(packetInfo.NIC)
+	return p
+}
+
 // errOriginToLinux maps tcpip socket origin to Linux socket origin constants.
 func errOriginToLinux(origin tcpip.SockErrOrigin) uint8 {
 	switch origin {
@@ -67,6 +76,10 @@ func errOriginToLinux(origin tcpip.SockErrOrigin) uint8 {
 		return linux.SO_EE_ORIGIN_ICMP
 	case tcpip.SockExtErrorOriginICMP6:
 		return linux.SO_EE_ORIGIN_ICMP6
+	case tcpip.SockExtErrorOriginTimestamping:
+		return linux.SO_EE_ORIGIN_TIMESTAMPING
+	case tcpip.SockExtErrorOriginZeroCopy:
+		return linux.SO_EE_ORIGIN_ZEROCOPY
 	default:
 		panic(fmt.Sprintf("unknown socket origin: %d", origin))
 	}
@@ -80,11 +93,22 @@ func sockErrCmsgToLinux(sockErr *tcpip.SockError) linux.SockErrCMsg {
 	}
 
 	ee := linux.SockExtendedErr{
-		Errno:  uint32(syserr.TranslateNetstackError(sockErr.Err).ToLinux().Number()),
 		Origin: errOriginToLinux(sockErr.ErrOrigin),
 		Type:   sockErr.ErrType,
 		Code:   sockErr.ErrCode,
 		Info:   sockErr.ErrInfo,
+		Data:   sockErr.ErrData,
+	}
+	switch sockErr.ErrOrigin {
+	case tcpip.SockExtErrorOriginTimestamping:
+		// Timestamps are not errors and are always reported with ENOMSG. See
+		// net/core/skbuff.c:__skb_tstamp_tx().
+		ee.Errno = uint32(linux.ENOMSG.Number())
+	case tcpip.SockExtErrorOriginZeroCopy:
+		// Zerocopy completions are reported without an errno. See
+		// net/core/skbuff.c:__msg_zerocopy_callback().
+	default:
+		ee.Errno = uint32(syserr.TranslateNetstackError(sockErr.Err).ToLinux().Number())
 	}
 
 	switch sockErr.NetProto {
@@ -125,8 +149,18 @@ func NewIPControlMessages(family int, cmgs tcpip.ControlMessages) IPControlMessa
 		TClass:             cmgs.TClass,
 		HasIPPacketInfo:    cmgs.HasIPPacketInfo,
 		PacketInfo:         packetInfoToLinux(cmgs.PacketInfo),
+		HasIPv6PacketInfo:  cmgs.HasIPv6PacketInfo,
+		IPv6PacketInfo:     ipv6PacketInfoToLinux(cmgs.IPv6PacketInfo),
+		HasTTL:             cmgs.HasTTL,
+		TTL:                cmgs.TTL,
+		HasHopLimit:        cmgs.HasHopLimit,
+		HopLimit:           cmgs.HopLimit,
+		HasFlowInfo:        cmgs.HasFlowInfo,
+		FlowInfo:           cmgs.FlowInfo,
 		OriginalDstAddress: orgDstAddr,
 		SockErr:            sockErrCmsgToLinux(cmgs.SockErr),
+		HasGROSize:         cmgs.HasGROSize,
+		GROSize:            cmgs.GROSize,
 	}
 }
 
@@ -142,6 +176,14 @@ type IPControlMessages struct {
 	// the read data was received.
 	Timestamp int64
 
+	// HasTimestampNS indicates whether Timestamp should be reported as an
+	// SCM_TIMESTAMPNS control message.
+	HasTimestampNS bool
+
+	// HasTimestamping indicates whether Timestamp should be reported as the
+	// software timestamp of an SCM_TIMESTAMPING control message.
+	HasTimestamping bool
+
 	// HasInq indicates whether Inq is valid/set.
 	HasInq bool
 
@@ -166,12 +208,60 @@ type IPControlMessages struct {
 	// PacketInfo holds interface and address data on an incoming packet.
 	PacketInfo linux.ControlMessageIPPacketInfo
 
+	// HasIPv6PacketInfo indicates whether IPv6PacketInfo is set.
+	HasIPv6PacketInfo bool
+
+	// IPv6PacketInfo holds interface and address data on an incoming IPv6
+	// packet.
+	IPv6PacketInfo linux.ControlMessageIPv6PacketInfo
+
+	// HasTTL indicates whether TTL is valid/set.
+	HasTTL bool
+
+	// TTL is the IPv4 time to live of the associated packet.
+	TTL uint8
+
+	// HasHopLimit indicates whether HopLimit is valid/set.
+	HasHopLimit bool
+
+	// HopLimit is the IPv6 hop limit of the associated packet.
+	HopLimit uint8
+
+	// HasFlowInfo indicates whether FlowInfo is valid/set.
+	HasFlowInfo bool
+
+	// FlowInfo holds the IPv6 traffic class and flow label of the associated
+	// packet.
+	FlowInfo uint32
+
 	// OriginalDestinationAddress holds the original destination address
 	// and port of the incoming packet.
 	OriginalDstAddress linux.SockAddr
 
 	// SockErr is the dequeued socket error on recvmsg(MSG_ERRQUEUE).
 	SockErr linux.SockErrCMsg
+
+	// HasGSOSize indicates whether GSOSize is valid/set.
+	HasGSOSize bool
+
+	// GSOSize is the size of the UDP datagrams that the data being sent
+	// should be split into, as requested with a UDP_SEGMENT control
+	// message.
+	GSOSize uint16
+
+	// HasGROSize indicates whether GROSize is valid/set.
+	HasGROSize bool
+
+	// GROSize is the size of the UDP datagrams that were coalesced into
+	// the read data.
+	GROSize uint16
+
+	// HasTLSRecordType indicates whether TLSRecordType is valid/set.
+	HasTLSRecordType bool
+
+	// TLSRecordType is the type of the TLS records holding the data, with
+	// kTLS.
+	TLSRecordType uint8
 }
 
 // Release releases Unix domain socket credentials and rights.
@@ -555,8 +645,7 @@ func emitUnimplementedEvent(t *kernel.Task, name int) {
 		linux.SO_TIMESTAMPING,
 		linux.SO_TIMESTAMPNS,
 		linux.SO_TXTIME,
-		linux.SO_WIFI_STATUS,
-		linux.SO_ZEROCOPY:
+		linux.SO_WIFI_STATUS:
 
 		t.Kernel().EmitUnimplementedEvent(t)
 	}
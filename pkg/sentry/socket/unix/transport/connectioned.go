@@ -114,6 +114,7 @@ type connectionedEndpoint struct {
 var (
 	_ = BoundEndpoint((*connectionedEndpoint)(nil))
 	_ = Endpoint((*connectionedEndpoint)(nil))
+	_ = ExternalAcceptor((*connectionedEndpoint)(nil))
 )
 
 // NewConnectioned creates a new unbound connectionedEndpoint.
@@ -346,6 +347,39 @@ func (e *connectionedEndpoint) BidirectionalConnect(ctx context.Context, ce Conn
 	}
 }
 
+// AcceptExternal implements ExternalAcceptor.AcceptExternal.
+func (e *connectionedEndpoint) AcceptExternal(ctx context.Context, queue *waiter.Queue, receiver Receiver, connected ConnectedEndpoint) *syserr.Error {
+	e.Lock()
+	if !e.Listening() {
+		e.Unlock()
+		return syserr.ErrConnectionRefused
+	}
+
+	ne := &connectionedEndpoint{
+		baseEndpoint: baseEndpoint{
+			path:      e.path,
+			Queue:     queue,
+			receiver:  receiver,
+			connected: connected,
+		},
+		id:          e.idGenerator.UniqueID(),
+		idGenerator: e.idGenerator,
+		stype:       e.stype,
+	}
+	ne.ops.InitHandler(ne)
+
+	select {
+	case e.acceptedChan <- ne:
+		e.Unlock()
+		e.Notify(waiter.EventIn)
+		return nil
+	default:
+		// Busy; return ECONNREFUSED per spec.
+		e.Unlock()
+		return syserr.ErrConnectionRefused
+	}
+}
+
 // UnidirectionalConnect implements BoundEndpoint.UnidirectionalConnect.
 func (e *connectionedEndpoint) UnidirectionalConnect(ctx context.Context) (ConnectedEndpoint, *syserr.Error) {
 	return nil, syserr.ErrConnectionRefused
@@ -255,6 +255,21 @@ type BoundEndpoint interface {
 	Release(ctx context.Context)
 }
 
+// An ExternalAcceptor is a BoundEndpoint that can accept connections from
+// peers outside of the sentry, such as host processes connecting to a host
+// socket file that the BoundEndpoint is bound to.
+type ExternalAcceptor interface {
+	// Type returns the socket type of the endpoint.
+	Type() linux.SockType
+
+	// AcceptExternal queues a connection, whose peer is represented by
+	// receiver and connected, to be returned by Accept. queue is the waiter
+	// queue notified by the peer. If the endpoint isn't listening or its
+	// backlog is full, AcceptExternal returns syserr.ErrConnectionRefused,
+	// and the caller retains ownership of receiver and connected.
+	AcceptExternal(ctx context.Context, queue *waiter.Queue, receiver Receiver, connected ConnectedEndpoint) *syserr.Error
+}
+
 // message represents a message passed over a Unix domain socket.
 //
 // +stateify savable
@@ -0,0 +1,188 @@
+// Copyright 2020 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package state
+
+import (
+	"fmt"
+	"io"
+	gotime "time"
+
+	"gvisor.dev/gvisor/pkg/context"
+	"gvisor.dev/gvisor/pkg/log"
+	"gvisor.dev/gvisor/pkg/sentry/inet"
+	"gvisor.dev/gvisor/pkg/sentry/kernel"
+	"gvisor.dev/gvisor/pkg/sentry/time"
+	"gvisor.dev/gvisor/pkg/sentry/vfs"
+	"gvisor.dev/gvisor/pkg/sentry/watchdog"
+	"gvisor.dev/gvisor/pkg/state"
+	"gvisor.dev/gvisor/pkg/state/statefile"
+	"gvisor.dev/gvisor/pkg/syserror"
+)
+
+// A migration stream is a statefile whose contents are a sequence of parts,
+// each preceded by a header whose length is one of the following. Memory
+// parts are written while the sandbox runs; the final part is written while
+// it is paused, and is the last part in the stream.
+const (
+	migrationMemory = 1
+	migrationFinal  = 2
+)
+
+// DefaultMaxMigrationRounds is the default value of MigrateOpts.MaxRounds.
+const DefaultMaxMigrationRounds = 30
+
+// MigrateOpts contains live migration options.
+type MigrateOpts struct {
+	// Destination is the migration target.
+	Destination io.Writer
+
+	// Key is used for state integrity check and encryption.
+	Key []byte
+
+	// Metadata is save metadata.
+	Metadata map[string]string
+
+	// MaxPause is the longest that tasks should be paused while the final
+	// part of the migration is transferred. Memory is copied while tasks run
+	// until the remaining changes can be transferred at the observed rate
+	// within MaxPause.
+	MaxPause gotime.Duration
+
+	// MaxRounds is the maximum number of times memory is copied while tasks
+	// run, which bounds the duration of migration of a sandbox that writes
+	// memory faster than it can be transferred. If MaxRounds is zero,
+	// DefaultMaxMigrationRounds is used.
+	MaxRounds int
+
+	// Callback is called prior to unpause, with any error in the final part
+	// of the migration. It is not called if migration fails before tasks are
+	// paused.
+	Callback func(err error)
+}
+
+// Migrate transfers the system state to a LoadOpts.LoadMigration on another
+// host while tasks continue to run, pausing them only to transfer the state
+// that has changed since the last copy.
+func (opts MigrateOpts) Migrate(ctx context.Context, k *kernel.Kernel, w *watchdog.Watchdog) error {
+	if err := k.StartDirtyTracking(); err != nil {
+		return fmt.Errorf("live migration unavailable: %v", err)
+	}
+	maxRounds := opts.MaxRounds
+	if maxRounds == 0 {
+		maxRounds = DefaultMaxMigrationRounds
+	}
+
+	// Supplement the metadata.
+	if opts.Metadata == nil {
+		opts.Metadata = make(map[string]string)
+	}
+	addSaveMetadata(opts.Metadata)
+
+	wc, err := statefile.NewWriter(opts.Destination, opts.Key, opts.Metadata)
+	if err != nil {
+		return ErrStateFile{err}
+	}
+
+	// Copy memory while tasks run. Each round copies the memory written
+	// during the previous one; stop once the remainder can be transferred
+	// within the pause budget, at the rate achieved by the last round.
+	var rate float64 // bytes per second
+	for round := 0; round < maxRounds; round++ {
+		if round > 0 {
+			pending, err := k.DirtyMemoryBytes()
+			if err != nil {
+				wc.Close()
+				return err
+			}
+			if pending == 0 || (rate > 0 && float64(pending)/rate <= opts.MaxPause.Seconds()) {
+				log.Infof("Migration: %d bytes of memory remaining after %d rounds", pending, round)
+				break
+			}
+		}
+		start := gotime.Now()
+		err := state.WriteHeader(wc, migrationMemory, false)
+		var n uint64
+		if err == nil {
+			n, err = k.SaveMemoryIncrement(ctx, wc, round == 0 /* full */)
+		}
+		if err != nil {
+			wc.Close()
+			return ErrStateFile{err}
+		}
+		d := gotime.Since(start)
+		if d > 0 {
+			rate = float64(n) / d.Seconds()
+		}
+		log.Infof("Migration: round %d copied %d bytes of memory in [%s]", round, n, d)
+	}
+
+	log.Infof("Migration final round started, pausing all tasks.")
+	pauseStart := gotime.Now()
+	k.Pause()
+	k.ReceiveTaskStates()
+	defer func() {
+		k.Unpause()
+		log.Infof("Tasks resumed after migration.")
+	}()
+
+	w.Stop()
+	defer w.Start()
+
+	err = state.WriteHeader(wc, migrationFinal, false)
+	if err == nil {
+		err = k.SaveIncrementalTo(ctx, wc)
+	}
+	// See SaveOpts.Save.
+	if err == syserror.ENOSPC {
+		err = ErrStateFile{err}
+	}
+	if closeErr := wc.Close(); err == nil && closeErr != nil {
+		err = ErrStateFile{closeErr}
+	}
+	log.Infof("Migration final round took [%s], pause budget [%s].", gotime.Since(pauseStart), opts.MaxPause)
+	opts.Callback(err)
+	return err
+}
+
+// LoadMigration loads the given kernel from a migration stream written by
+// MigrateOpts.Migrate, setting the provided platform and stack.
+func (opts LoadOpts) LoadMigration(ctx context.Context, k *kernel.Kernel, n inet.Stack, clocks time.Clocks, vfsOpts *vfs.CompleteRestoreOptions) error {
+	r, m, err := statefile.NewReader(opts.Source, opts.Key)
+	if err != nil {
+		return ErrStateFile{err}
+	}
+	previousMetadata = m
+
+	for round := 0; ; round++ {
+		part, object, err := state.ReadHeader(r)
+		if err != nil {
+			return ErrStateFile{err}
+		}
+		if object {
+			return ErrStateFile{fmt.Errorf("unexpected object in migration stream")}
+		}
+		switch part {
+		case migrationMemory:
+			if err := k.LoadMemoryIncrement(ctx, r); err != nil {
+				return err
+			}
+		case migrationFinal:
+			log.Infof("Migration: received %d rounds of memory", round)
+			return k.LoadIncrementalFrom(ctx, r, n, clocks, vfsOpts)
+		default:
+			return ErrStateFile{fmt.Errorf("unknown migration stream part %d", part)}
+		}
+	}
+}
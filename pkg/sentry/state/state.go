@@ -18,9 +18,11 @@ package state
 import (
 	"fmt"
 	"io"
+	gotime "time"
 
 	"gvisor.dev/gvisor/pkg/context"
 	"gvisor.dev/gvisor/pkg/log"
+	"gvisor.dev/gvisor/pkg/otlp"
 	"gvisor.dev/gvisor/pkg/sentry/inet"
 	"gvisor.dev/gvisor/pkg/sentry/kernel"
 	"gvisor.dev/gvisor/pkg/sentry/time"
@@ -48,21 +50,36 @@ type SaveOpts struct {
 	// Destination is the save target.
 	Destination io.Writer
 
-	// Key is used for state integrity check.
+	// Key is used for state integrity check and encryption.
 	Key []byte
 
 	// Metadata is save metadata.
 	Metadata map[string]string
 
+	// Compression is the state file compression setting. If empty,
+	// statefile.CompressionDefault is used.
+	Compression statefile.Compression
+
+	// Workers is the number of goroutines that compress the state file, or
+	// zero for the default.
+	Workers int
+
 	// Callback is called prior to unpause, with any save error.
 	Callback func(err error)
 }
 
 // Save saves the system state.
-func (opts SaveOpts) Save(ctx context.Context, k *kernel.Kernel, w *watchdog.Watchdog) error {
+//
+// The whole save and its phases are exported as spans with otlp.Default.
+func (opts SaveOpts) Save(ctx context.Context, k *kernel.Kernel, w *watchdog.Watchdog) (err error) {
+	span := otlp.Default().Start("checkpoint", otlp.String("checkpoint.compression", string(opts.Compression)))
+	defer func() { span.End(err) }()
+
 	log.Infof("Sandbox save started, pausing all tasks.")
+	pauseStart := gotime.Now()
 	k.Pause()
 	k.ReceiveTaskStates()
+	otlp.Default().Record("checkpoint.pause", pauseStart, gotime.Now(), nil)
 	defer func() {
 		k.Unpause()
 		log.Infof("Tasks resumed after save.")
@@ -78,7 +95,10 @@ func (opts SaveOpts) Save(ctx context.Context, k *kernel.Kernel, w *watchdog.Wat
 	addSaveMetadata(opts.Metadata)
 
 	// Open the statefile.
-	wc, err := statefile.NewWriter(opts.Destination, opts.Key, opts.Metadata)
+	wc, err := statefile.NewWriterOpts(opts.Destination, opts.Key, opts.Metadata, statefile.WriterOpts{
+		Compression: opts.Compression,
+		Workers:     opts.Workers,
+	})
 	if err != nil {
 		err = ErrStateFile{err}
 	} else {
@@ -105,12 +125,17 @@ type LoadOpts struct {
 	// Destination is the load source.
 	Source io.Reader
 
-	// Key is used for state integrity check.
+	// Key is used for state integrity check and encryption.
 	Key []byte
 }
 
 // Load loads the given kernel, setting the provided platform and stack.
-func (opts LoadOpts) Load(ctx context.Context, k *kernel.Kernel, n inet.Stack, clocks time.Clocks, vfsOpts *vfs.CompleteRestoreOptions) error {
+//
+// The whole load and its phases are exported as spans with otlp.Default.
+func (opts LoadOpts) Load(ctx context.Context, k *kernel.Kernel, n inet.Stack, clocks time.Clocks, vfsOpts *vfs.CompleteRestoreOptions) (err error) {
+	span := otlp.Default().Start("restore")
+	defer func() { span.End(err) }()
+
 	// Open the file.
 	r, m, err := statefile.NewReader(opts.Source, opts.Key)
 	if err != nil {
@@ -0,0 +1,228 @@
+// Copyright 2020 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package strace
+
+import (
+	"fmt"
+
+	"golang.org/x/time/rate"
+	"gvisor.dev/gvisor/pkg/sentry/arch"
+	"gvisor.dev/gvisor/pkg/sentry/kernel"
+	"gvisor.dev/gvisor/pkg/sync"
+)
+
+// redactedArg is the formatted value of redacted arguments.
+const redactedArg = "<redacted>"
+
+// Redact causes argument arg, counting from 0, of the named syscall to be
+// redacted from straces. If arg is negative, all of its arguments are
+// redacted. Redacted arguments are not read from the traced task's memory.
+//
+// Preconditions: Strace is not enabled.
+func Redact(name string, arg int) error {
+	if arg >= len(arch.SyscallArguments{}) {
+		return fmt.Errorf("syscall %q has no argument %d", name, arg)
+	}
+	found := false
+	for _, table := range syscallTables {
+		sysno, ok := table.syscalls.ConvertToSysno(name)
+		if !ok {
+			continue
+		}
+		found = true
+		info := table.syscalls[sysno]
+		if arg < 0 {
+			info.redact = ^uint8(0)
+		} else {
+			info.redact |= 1 << uint(arg)
+		}
+		table.syscalls[sysno] = info
+	}
+	if !found {
+		return fmt.Errorf("syscall %q not found", name)
+	}
+	return nil
+}
+
+// RedactBuffers causes the data buffers of all syscalls, such as those of
+// read, write, sendmsg and recvmsg, to be redacted from straces.
+//
+// Preconditions: Strace is not enabled.
+func RedactBuffers() {
+	for _, table := range syscallTables {
+		for sysno, info := range table.syscalls {
+			for arg, f := range info.format {
+				switch f {
+				case ReadBuffer, WriteBuffer, ReadIOVec, WriteIOVec, SendMsgHdr, RecvMsgHdr:
+					info.redact |= 1 << uint(arg)
+				}
+			}
+			table.syscalls[sysno] = info
+		}
+	}
+}
+
+// redacted returns true if argument arg of the syscall is redacted.
+func (i *SyscallInfo) redacted(arg int) bool {
+	return i.redact&(1<<uint(arg)) != 0
+}
+
+// SetRateLimit limits the straces of the named syscall to perSecond, with
+// bursts of up to burst syscalls. Syscalls over the limit aren't traced by
+// any sink. If name is "*", the limit applies separately to each syscall that
+// doesn't have its own.
+//
+// Preconditions: Strace is not enabled.
+func SetRateLimit(name string, perSecond float64, burst int) error {
+	found := false
+	for _, table := range syscallTables {
+		if name == "*" {
+			for sysno, info := range table.syscalls {
+				if info.limiter == nil {
+					info.limiter = rate.NewLimiter(rate.Limit(perSecond), burst)
+					table.syscalls[sysno] = info
+				}
+			}
+			found = true
+			continue
+		}
+		sysno, ok := table.syscalls.ConvertToSysno(name)
+		if !ok {
+			continue
+		}
+		found = true
+		info := table.syscalls[sysno]
+		info.limiter = rate.NewLimiter(rate.Limit(perSecond), burst)
+		table.syscalls[sysno] = info
+	}
+	if !found {
+		return fmt.Errorf("syscall %q not found", name)
+	}
+	return nil
+}
+
+// taskFilter selects the syscalls of tasks that are traced by the log sink.
+type taskFilter struct {
+	// enabled is true if syscalls are traced.
+	enabled bool
+
+	// syscalls is the set of names of the traced syscalls, or nil if all
+	// syscalls are traced.
+	syscalls map[string]struct{}
+}
+
+// traces returns true if f traces the syscall named name.
+func (f *taskFilter) traces(name string) bool {
+	if !f.enabled {
+		return false
+	}
+	if f.syscalls == nil {
+		return true
+	}
+	_, ok := f.syscalls[name]
+	return ok
+}
+
+// nameSet returns the set of names.
+func nameSet(names []string) map[string]struct{} {
+	set := make(map[string]struct{})
+	for _, name := range names {
+		set[name] = struct{}{}
+	}
+	return set
+}
+
+var (
+	// filtersMu protects the variables below.
+	filtersMu sync.RWMutex
+
+	// logFilter is the filter of the syscalls enabled for the log sink by
+	// Enable, EnableAll and Disable.
+	logFilter taskFilter
+
+	// containerFilters maps container IDs to the filters of their tasks. It
+	// is nil until SetContainerFilter is first called. After that, all
+	// syscalls are enabled for the log sink, and filtered with the filter of
+	// the task's container, or with logFilter if it has none.
+	containerFilters map[string]taskFilter
+)
+
+// setLogFilter sets logFilter to f.
+func setLogFilter(f taskFilter) {
+	filtersMu.Lock()
+	defer filtersMu.Unlock()
+	logFilter = f
+}
+
+// SetContainerFilter sets which syscalls of the tasks of the container with
+// the given ID are traced by the log sink, overriding the syscalls enabled
+// with Enable or EnableAll. If enabled is false, none are traced. Otherwise,
+// the syscalls named in syscalls are, or all syscalls if it is empty.
+//
+// Preconditions: Initialize has been called. Enable, EnableAll and Disable are
+// not called for the log sink afterwards.
+func SetContainerFilter(cid string, enabled bool, syscalls []string) error {
+	for _, name := range syscalls {
+		found := false
+		for _, table := range syscallTables {
+			if _, ok := table.syscalls.ConvertToSysno(name); ok {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return fmt.Errorf("syscall %q not found", name)
+		}
+	}
+	f := taskFilter{enabled: enabled}
+	if len(syscalls) > 0 {
+		f.syscalls = nameSet(syscalls)
+	}
+
+	filtersMu.Lock()
+	defer filtersMu.Unlock()
+	if containerFilters == nil {
+		containerFilters = make(map[string]taskFilter)
+		enableAllTables(kernel.StraceEnableLog)
+	}
+	containerFilters[cid] = f
+	return nil
+}
+
+// ClearContainerFilter removes the filter set by SetContainerFilter for the
+// container with the given ID.
+func ClearContainerFilter(cid string) {
+	filtersMu.Lock()
+	defer filtersMu.Unlock()
+	delete(containerFilters, cid)
+}
+
+// filterFlags returns flags, without kernel.StraceEnableLog if the syscall
+// named name isn't traced for t by the filter of its container.
+func filterFlags(t *kernel.Task, name string, flags uint32) uint32 {
+	filtersMu.RLock()
+	defer filtersMu.RUnlock()
+	if containerFilters == nil {
+		return flags
+	}
+	f, ok := containerFilters[t.ContainerID()]
+	if !ok {
+		f = logFilter
+	}
+	if !f.traces(name) {
+		flags &^= kernel.StraceEnableLog
+	}
+	return flags
+}
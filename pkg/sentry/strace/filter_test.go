@@ -0,0 +1,236 @@
+// Copyright 2020 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package strace
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"golang.org/x/time/rate"
+	"gvisor.dev/gvisor/pkg/abi/linux"
+	"gvisor.dev/gvisor/pkg/sentry/arch"
+	"gvisor.dev/gvisor/pkg/sentry/fsimpl/testutil"
+	"gvisor.dev/gvisor/pkg/sentry/fsimpl/tmpfs"
+	"gvisor.dev/gvisor/pkg/sentry/kernel"
+	"gvisor.dev/gvisor/pkg/sentry/kernel/auth"
+	"gvisor.dev/gvisor/pkg/sentry/vfs"
+	"gvisor.dev/gvisor/pkg/syserror"
+)
+
+// resetFilters removes the redactions and rate limits of all syscalls when
+// the test completes.
+func resetFilters(t *testing.T) {
+	t.Cleanup(func() {
+		for _, table := range syscallTables {
+			for sysno, info := range table.syscalls {
+				info.redact = 0
+				info.limiter = nil
+				table.syscalls[sysno] = info
+			}
+		}
+	})
+}
+
+// lookupInfo returns the SyscallInfo of the named syscall in each table that
+// has it.
+func lookupInfo(t *testing.T, name string) []SyscallInfo {
+	t.Helper()
+	var infos []SyscallInfo
+	for _, table := range syscallTables {
+		if sysno, ok := table.syscalls.ConvertToSysno(name); ok {
+			infos = append(infos, table.syscalls[sysno])
+		}
+	}
+	if len(infos) == 0 {
+		t.Fatalf("syscall %q not found", name)
+	}
+	return infos
+}
+
+func TestRedact(t *testing.T) {
+	resetFilters(t)
+
+	if err := Redact("write", 1); err != nil {
+		t.Fatalf("Redact failed: %v", err)
+	}
+	if err := Redact("close", -1); err != nil {
+		t.Fatalf("Redact failed: %v", err)
+	}
+	for _, info := range lookupInfo(t, "write") {
+		if info.redacted(0) || !info.redacted(1) || info.redacted(2) {
+			t.Errorf("write got redact %#b, want %#b", info.redact, 1<<1)
+		}
+	}
+	for _, info := range lookupInfo(t, "close") {
+		if !info.redacted(0) {
+			t.Errorf("close got redact %#b, want all arguments", info.redact)
+		}
+	}
+	for _, info := range lookupInfo(t, "read") {
+		if info.redact != 0 {
+			t.Errorf("read got redact %#b, want none", info.redact)
+		}
+	}
+
+	if err := Redact("nonexistent", 0); err == nil {
+		t.Errorf("Redact of an unknown syscall succeeded")
+	}
+	if err := Redact("write", len(arch.SyscallArguments{})); err == nil {
+		t.Errorf("Redact of an out of range argument succeeded")
+	}
+}
+
+func TestRedactBuffers(t *testing.T) {
+	resetFilters(t)
+
+	RedactBuffers()
+	for _, name := range []string{"read", "write", "sendmsg", "recvmsg"} {
+		for _, info := range lookupInfo(t, name) {
+			if info.redacted(0) || !info.redacted(1) {
+				t.Errorf("%s got redact %#b, want %#b", name, info.redact, 1<<1)
+			}
+		}
+	}
+	for _, info := range lookupInfo(t, "close") {
+		if info.redact != 0 {
+			t.Errorf("close got redact %#b, want none", info.redact)
+		}
+	}
+}
+
+// Redacted arguments are neither read from memory before nor after the
+// syscall, which would fault here without a task.
+func TestRedactedFormat(t *testing.T) {
+	args := arch.SyscallArguments{{Value: 1}, {Value: 2}, {Value: 3}}
+	for _, f := range []FormatSpecifier{WriteBuffer, ReadBuffer} {
+		info := makeSyscallInfo("test", Hex, f, Hex)
+		info.redact = 1 << 1
+		output := info.pre(nil, args, LogMaximumSize)
+		info.post(nil, args, 3, output, LogMaximumSize)
+		if want := []string{"0x1", redactedArg, "0x3"}; !reflect.DeepEqual(output, want) {
+			t.Errorf("format %d got %v, want %v", f, output, want)
+		}
+	}
+}
+
+func TestSetRateLimit(t *testing.T) {
+	resetFilters(t)
+
+	if err := SetRateLimit("read", 10, 1); err != nil {
+		t.Fatalf("SetRateLimit failed: %v", err)
+	}
+	if err := SetRateLimit("*", 100, 5); err != nil {
+		t.Fatalf("SetRateLimit failed: %v", err)
+	}
+	for _, info := range lookupInfo(t, "read") {
+		if info.limiter == nil || info.limiter.Limit() != 10 || info.limiter.Burst() != 1 {
+			t.Errorf("read got limiter %+v, want 10/s with bursts of 1", info.limiter)
+		}
+	}
+	// The default limit applies separately to each other syscall.
+	var limiters []*rate.Limiter
+	for _, name := range []string{"write", "close"} {
+		for _, info := range lookupInfo(t, name) {
+			if info.limiter == nil || info.limiter.Limit() != 100 || info.limiter.Burst() != 5 {
+				t.Errorf("%s got limiter %+v, want 100/s with bursts of 5", name, info.limiter)
+			}
+			limiters = append(limiters, info.limiter)
+		}
+	}
+	if len(limiters) >= 2 && limiters[0] == limiters[len(limiters)-1] {
+		t.Errorf("write and close share a limiter")
+	}
+
+	if err := SetRateLimit("nonexistent", 1, 1); err == nil {
+		t.Errorf("SetRateLimit of an unknown syscall succeeded")
+	}
+}
+
+// recordingSink is a LogSink that records the straces passed to it.
+type recordingSink struct {
+	enters []*Record
+	exits  []*Record
+}
+
+// Enter implements LogSink.Enter.
+func (s *recordingSink) Enter(_ *kernel.Task, r *Record) {
+	s.enters = append(s.enters, r)
+}
+
+// Exit implements LogSink.Exit.
+func (s *recordingSink) Exit(_ *kernel.Task, r *Record) {
+	s.exits = append(s.exits, r)
+}
+
+// newTestTask returns a task in a new thread group.
+func newTestTask(t *testing.T) *kernel.Task {
+	t.Helper()
+	k, err := testutil.Boot()
+	if err != nil {
+		t.Fatalf("Failed to create test kernel: %v", err)
+	}
+	ctx := k.SupervisorContext()
+	creds := auth.CredentialsFromContext(ctx)
+	mns, err := k.VFS().NewMountNamespace(ctx, creds, "", tmpfs.Name, &vfs.MountOptions{})
+	if err != nil {
+		t.Fatalf("Failed to create new mount namespace: %v", err)
+	}
+	s := testutil.NewSystem(ctx, t, k.VFS(), mns)
+	t.Cleanup(s.Destroy)
+	tg := k.NewThreadGroup(nil, k.RootPIDNamespace(), kernel.NewSignalHandlers(), linux.SIGCHLD, k.GlobalInit().Limits())
+	task, err := testutil.CreateTask(ctx, "strace", tg, mns, s.Root, s.Root)
+	if err != nil {
+		t.Fatalf("CreateTask failed: %v", err)
+	}
+	return task
+}
+
+func TestRateLimitedSyscallsNotTraced(t *testing.T) {
+	task := newTestTask(t)
+	sink := &recordingSink{}
+	SetLogSink(sink)
+	defer SetLogSink(textSink{})
+
+	info := makeSyscallInfo("test", Hex)
+	// Allow a single syscall per hour.
+	info.limiter = rate.NewLimiter(rate.Every(time.Hour), 1)
+	m := SyscallMap{0: info}
+	args := arch.SyscallArguments{{Value: 1}}
+	for i := 0; i < 3; i++ {
+		ctx := m.SyscallEnter(task, 0, args, kernel.StraceEnableLog)
+		m.SyscallExit(ctx, task, 0, ^uintptr(1), syserror.ENOENT)
+	}
+
+	if len(sink.enters) != 1 || len(sink.exits) != 1 {
+		t.Fatalf("got %d entries and %d exits, want 1 of each", len(sink.enters), len(sink.exits))
+	}
+	r := sink.exits[0]
+	root := task.Kernel().TaskSet().Root
+	want := Record{
+		Process:  task.Name(),
+		PID:      root.IDOfThreadGroup(task.ThreadGroup()),
+		TID:      root.IDOfTask(task),
+		Syscall:  "test",
+		Args:     []string{"0x1"},
+		Return:   ^uintptr(1),
+		Errno:    linux.ENOENT.Number(),
+		Error:    syserror.ENOENT.Error(),
+		Duration: r.Duration,
+	}
+	if !reflect.DeepEqual(*r, want) {
+		t.Errorf("exit got %+v, want %+v", *r, want)
+	}
+}
@@ -0,0 +1,151 @@
+// Copyright 2020 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package strace
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"gvisor.dev/gvisor/pkg/log"
+	"gvisor.dev/gvisor/pkg/sentry/kernel"
+	"gvisor.dev/gvisor/pkg/sync"
+)
+
+// Record is a traced syscall, as passed to a LogSink.
+type Record struct {
+	// Process is the name of the calling task.
+	Process string
+
+	// PID and TID are the thread group and thread IDs of the caller in the
+	// root PID namespace.
+	PID kernel.ThreadID
+	TID kernel.ThreadID
+
+	// Syscall is the name of the syscall.
+	Syscall string
+
+	// Args are the formatted arguments of the syscall. On exit, they include
+	// the arguments that are only formatted after the syscall is executed.
+	Args []string
+
+	// The following fields are only set on exit.
+
+	// Return is the raw return value of the syscall.
+	Return uintptr
+
+	// Errno and Error describe the error returned by the syscall, if any.
+	Errno int
+	Error string
+
+	// Duration is how long the syscall took.
+	Duration time.Duration
+}
+
+// LogSink receives the straces of SinkTypeLog.
+type LogSink interface {
+	// Enter is called when t enters a traced syscall.
+	Enter(t *kernel.Task, r *Record)
+
+	// Exit is called when t exits a traced syscall.
+	Exit(t *kernel.Task, r *Record)
+}
+
+// logSink receives the straces of SinkTypeLog.
+var logSink LogSink = textSink{}
+
+// SetLogSink sets the sink of SinkTypeLog straces. By default, they are
+// printed as text to the sentry log.
+//
+// Preconditions: Strace is not enabled.
+func SetLogSink(s LogSink) {
+	logSink = s
+}
+
+// textSink prints straces as text to the log of the traced task.
+type textSink struct{}
+
+// Enter implements LogSink.Enter.
+func (textSink) Enter(t *kernel.Task, r *Record) {
+	t.Infof("%s E %s(%s)", r.Process, r.Syscall, strings.Join(r.Args, ", "))
+}
+
+// Exit implements LogSink.Exit.
+func (textSink) Exit(t *kernel.Task, r *Record) {
+	var rval string
+	if r.Error == "" {
+		rval = fmt.Sprintf("%#x (%v)", r.Return, r.Duration)
+	} else {
+		rval = fmt.Sprintf("%#x errno=%d (%s) (%v)", r.Return, r.Errno, r.Error, r.Duration)
+	}
+	t.Infof("%s X %s(%s) = %s", r.Process, r.Syscall, strings.Join(r.Args, ", "), rval)
+}
+
+// JSONSink writes straces as JSON objects, one per line. Only syscall exits
+// are written, so syscalls that don't return, such as exit, aren't.
+type JSONSink struct {
+	// mu serializes writes to w, so that lines aren't interleaved.
+	mu sync.Mutex
+	w  io.Writer
+}
+
+// NewJSONSink returns a JSONSink that writes to w.
+func NewJSONSink(w io.Writer) *JSONSink {
+	return &JSONSink{w: w}
+}
+
+// jsonRecord is the JSON encoding of a Record.
+type jsonRecord struct {
+	PID        kernel.ThreadID `json:"pid"`
+	TID        kernel.ThreadID `json:"tid"`
+	Process    string          `json:"process"`
+	Syscall    string          `json:"syscall"`
+	Args       []string        `json:"args"`
+	Result     string          `json:"result"`
+	Errno      int             `json:"errno,omitempty"`
+	Error      string          `json:"error,omitempty"`
+	DurationNS int64           `json:"duration_ns"`
+}
+
+// Enter implements LogSink.Enter.
+func (*JSONSink) Enter(*kernel.Task, *Record) {}
+
+// Exit implements LogSink.Exit.
+func (s *JSONSink) Exit(t *kernel.Task, r *Record) {
+	b, err := json.Marshal(&jsonRecord{
+		PID:        r.PID,
+		TID:        r.TID,
+		Process:    r.Process,
+		Syscall:    r.Syscall,
+		Args:       r.Args,
+		Result:     fmt.Sprintf("%#x", r.Return),
+		Errno:      r.Errno,
+		Error:      r.Error,
+		DurationNS: r.Duration.Nanoseconds(),
+	})
+	if err != nil {
+		log.Warningf("Encoding strace of %s: %v", r.Syscall, err)
+		return
+	}
+	b = append(b, '\n')
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, err := s.w.Write(b); err != nil {
+		log.Warningf("Writing strace of %s: %v", r.Syscall, err)
+	}
+}
@@ -0,0 +1,91 @@
+// Copyright 2020 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package strace
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestJSONSink(t *testing.T) {
+	var buf bytes.Buffer
+	s := NewJSONSink(&buf)
+
+	// Entries aren't written.
+	s.Enter(nil, &Record{Syscall: "read"})
+	if buf.Len() != 0 {
+		t.Fatalf("Enter wrote %q, want nothing", buf.String())
+	}
+
+	s.Exit(nil, &Record{
+		Process:  "cat",
+		PID:      2,
+		TID:      3,
+		Syscall:  "read",
+		Args:     []string{"0x3 /etc/passwd", redactedArg, "0x1000"},
+		Return:   0x10,
+		Duration: 5 * time.Microsecond,
+	})
+	s.Exit(nil, &Record{
+		Process:  "cat",
+		PID:      2,
+		TID:      3,
+		Syscall:  "openat",
+		Args:     []string{},
+		Return:   ^uintptr(1),
+		Errno:    2,
+		Error:    "no such file or directory",
+		Duration: time.Microsecond,
+	})
+
+	lines := strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("got %d lines, want 2: %q", len(lines), buf.String())
+	}
+	for i, want := range []map[string]interface{}{
+		{
+			"pid":         2.0,
+			"tid":         3.0,
+			"process":     "cat",
+			"syscall":     "read",
+			"args":        []interface{}{"0x3 /etc/passwd", redactedArg, "0x1000"},
+			"result":      "0x10",
+			"duration_ns": 5000.0,
+		},
+		{
+			"pid":         2.0,
+			"tid":         3.0,
+			"process":     "cat",
+			"syscall":     "openat",
+			"args":        []interface{}{},
+			"result":      "0xfffffffffffffffe",
+			"errno":       2.0,
+			"error":       "no such file or directory",
+			"duration_ns": 1000.0,
+		},
+	} {
+		var got map[string]interface{}
+		if err := json.Unmarshal([]byte(lines[i]), &got); err != nil {
+			t.Fatalf("Unmarshal of line %d failed: %v", i, err)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("line %d got %v, want %v", i, got, want)
+		}
+	}
+}
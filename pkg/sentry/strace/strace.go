@@ -407,6 +407,10 @@ func (i *SyscallInfo) pre(t *kernel.Task, args arch.SyscallArguments, maximumBlo
 		if arg >= len(i.format) {
 			break
 		}
+		if i.redacted(arg) {
+			output = append(output, redactedArg)
+			continue
+		}
 		switch i.format[arg] {
 		case FD:
 			output = append(output, fd(t, args[arg].Int()))
@@ -508,6 +512,9 @@ func (i *SyscallInfo) post(t *kernel.Task, args arch.SyscallArguments, rval uint
 		if arg >= len(i.format) {
 			break
 		}
+		if i.redacted(arg) {
+			continue
+		}
 		switch i.format[arg] {
 		case ReadBuffer:
 			output[arg] = dump(t, args[arg].Pointer(), uint(rval), maximumBlobSize)
@@ -566,69 +573,38 @@ func (i *SyscallInfo) post(t *kernel.Task, args arch.SyscallArguments, rval uint
 	}
 }
 
-// printEntry prints the given system call entry.
+// printEnter passes the given system call entry to the log sink.
 func (i *SyscallInfo) printEnter(t *kernel.Task, args arch.SyscallArguments) []string {
 	output := i.pre(t, args, LogMaximumSize)
-
-	switch len(output) {
-	case 0:
-		t.Infof("%s E %s()", t.Name(), i.name)
-	case 1:
-		t.Infof("%s E %s(%s)", t.Name(), i.name,
-			output[0])
-	case 2:
-		t.Infof("%s E %s(%s, %s)", t.Name(), i.name,
-			output[0], output[1])
-	case 3:
-		t.Infof("%s E %s(%s, %s, %s)", t.Name(), i.name,
-			output[0], output[1], output[2])
-	case 4:
-		t.Infof("%s E %s(%s, %s, %s, %s)", t.Name(), i.name,
-			output[0], output[1], output[2], output[3])
-	case 5:
-		t.Infof("%s E %s(%s, %s, %s, %s, %s)", t.Name(), i.name,
-			output[0], output[1], output[2], output[3], output[4])
-	case 6:
-		t.Infof("%s E %s(%s, %s, %s, %s, %s, %s)", t.Name(), i.name,
-			output[0], output[1], output[2], output[3], output[4], output[5])
-	}
-
+	logSink.Enter(t, i.record(t, output))
 	return output
 }
 
-// printExit prints the given system call exit.
+// printExit passes the given system call exit to the log sink.
 func (i *SyscallInfo) printExit(t *kernel.Task, elapsed time.Duration, output []string, args arch.SyscallArguments, retval uintptr, err error, errno int) {
-	var rval string
+	r := i.record(t, output)
+	r.Return = retval
+	r.Duration = elapsed
 	if err == nil {
 		// Fill in the output after successful execution.
 		i.post(t, args, retval, output, LogMaximumSize)
-		rval = fmt.Sprintf("%#x (%v)", retval, elapsed)
 	} else {
-		rval = fmt.Sprintf("%#x errno=%d (%s) (%v)", retval, errno, err, elapsed)
-	}
-
-	switch len(output) {
-	case 0:
-		t.Infof("%s X %s() = %s", t.Name(), i.name,
-			rval)
-	case 1:
-		t.Infof("%s X %s(%s) = %s", t.Name(), i.name,
-			output[0], rval)
-	case 2:
-		t.Infof("%s X %s(%s, %s) = %s", t.Name(), i.name,
-			output[0], output[1], rval)
-	case 3:
-		t.Infof("%s X %s(%s, %s, %s) = %s", t.Name(), i.name,
-			output[0], output[1], output[2], rval)
-	case 4:
-		t.Infof("%s X %s(%s, %s, %s, %s) = %s", t.Name(), i.name,
-			output[0], output[1], output[2], output[3], rval)
-	case 5:
-		t.Infof("%s X %s(%s, %s, %s, %s, %s) = %s", t.Name(), i.name,
-			output[0], output[1], output[2], output[3], output[4], rval)
-	case 6:
-		t.Infof("%s X %s(%s, %s, %s, %s, %s, %s) = %s", t.Name(), i.name,
-			output[0], output[1], output[2], output[3], output[4], output[5], rval)
+		r.Errno = errno
+		r.Error = err.Error()
+	}
+	logSink.Exit(t, r)
+}
+
+// record returns a Record of the system call by t with the given formatted
+// arguments.
+func (i *SyscallInfo) record(t *kernel.Task, output []string) *Record {
+	root := t.Kernel().TaskSet().Root
+	return &Record{
+		Process: t.Name(),
+		PID:     root.IDOfThreadGroup(t.ThreadGroup()),
+		TID:     root.IDOfTask(t),
+		Syscall: i.name,
+		Args:    output,
 	}
 }
 
@@ -696,6 +672,11 @@ func (s SyscallMap) SyscallEnter(t *kernel.Task, sysno uintptr, args arch.Syscal
 			format: defaultFormat,
 		}
 	}
+	flags = filterFlags(t, info.name, flags)
+	if flags == 0 || (info.limiter != nil && !info.limiter.Allow()) {
+		// Don't trace this syscall on exit either.
+		return &syscallContext{}
+	}
 
 	var output, eventOutput []string
 	if bits.IsOn32(flags, kernel.StraceEnableLog) {
@@ -816,6 +797,9 @@ func convertToSyscallFlag(sinks SinkType) uint32 {
 // Preconditions: Initialize has been called.
 func Enable(whitelist []string, sinks SinkType) error {
 	flags := convertToSyscallFlag(sinks)
+	if bits.IsOn32(flags, kernel.StraceEnableLog) {
+		setLogFilter(taskFilter{enabled: true, syscalls: nameSet(whitelist)})
+	}
 	for _, table := range kernel.SyscallTables() {
 		// Is this known?
 		sys, ok := Lookup(table.OS, table.Arch)
@@ -841,6 +825,9 @@ func Enable(whitelist []string, sinks SinkType) error {
 // Preconditions: Initialize has been called.
 func Disable(sinks SinkType) {
 	flags := convertToSyscallFlag(sinks)
+	if bits.IsOn32(flags, kernel.StraceEnableLog) {
+		setLogFilter(taskFilter{})
+	}
 	for _, table := range kernel.SyscallTables() {
 		// Strace will be disabled for all syscalls including missing.
 		table.FeatureEnable.Enable(flags, nil, false)
@@ -852,6 +839,14 @@ func Disable(sinks SinkType) {
 // Preconditions: Initialize has been called.
 func EnableAll(sinks SinkType) {
 	flags := convertToSyscallFlag(sinks)
+	if bits.IsOn32(flags, kernel.StraceEnableLog) {
+		setLogFilter(taskFilter{enabled: true})
+	}
+	enableAllTables(flags)
+}
+
+// enableAllTables sets flags for all syscalls in all syscall tables.
+func enableAllTables(flags uint32) {
 	for _, table := range kernel.SyscallTables() {
 		// Is this known?
 		if _, ok := Lookup(table.OS, table.Arch); !ok {
@@ -15,6 +15,7 @@
 package strace
 
 import (
+	"golang.org/x/time/rate"
 	"gvisor.dev/gvisor/pkg/abi"
 	"gvisor.dev/gvisor/pkg/sentry/arch"
 	"gvisor.dev/gvisor/pkg/sentry/kernel"
@@ -254,6 +255,14 @@ type SyscallInfo struct {
 	// Syscall calls can have up to six arguments. Arguments without a
 	// corresponding entry in format will not be printed.
 	format []FormatSpecifier
+
+	// redact is a bitmask of the arguments that are redacted. It is set by
+	// Redact and RedactBuffers.
+	redact uint8
+
+	// limiter, if not nil, limits the rate at which the syscall is traced.
+	// It is set by SetRateLimit.
+	limiter *rate.Limiter
 }
 
 // makeSyscallInfo returns a SyscallInfo for a syscall.
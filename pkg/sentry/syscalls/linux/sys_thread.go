@@ -422,12 +422,12 @@ func Waitid(t *kernel.Task, args arch.SyscallArguments) (uintptr, *kernel.Syscal
 	case s.Exited():
 		si.Code = arch.CLD_EXITED
 		si.SetStatus(int32(s.ExitStatus()))
-	case s.Signaled():
-		si.Code = arch.CLD_KILLED
-		si.SetStatus(int32(s.Signal()))
 	case s.CoreDump():
 		si.Code = arch.CLD_DUMPED
 		si.SetStatus(int32(s.Signal()))
+	case s.Signaled():
+		si.Code = arch.CLD_KILLED
+		si.SetStatus(int32(s.Signal()))
 	case s.Stopped():
 		if wr.Event == kernel.EventTraceeStop {
 			si.Code = arch.CLD_TRAPPED
@@ -538,7 +538,7 @@ func SchedGetaffinity(t *kernel.Task, args arch.SyscallArguments) (uintptr, *ker
 		}
 	}
 
-	mask := task.CPUMask()
+	mask := task.OnlineCPUMask()
 	// The buffer needs to be big enough to hold a cpumask with
 	// all possible cpus.
 	if size < mask.Size() {
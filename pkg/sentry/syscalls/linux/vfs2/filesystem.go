@@ -134,6 +134,15 @@ func mknodat(t *kernel.Task, dirfd int32, addr usermem.Addr, mode linux.FileMode
 		mode |= linux.ModeRegular
 	}
 	major, minor := linux.DecodeDeviceID(dev)
+	// Device special files may only be created with CAP_MKNOD, except for
+	// overlay whiteouts. See fs/namei.c:vfs_mknod().
+	switch mode.FileType() {
+	case linux.ModeCharacterDevice, linux.ModeBlockDevice:
+		isWhiteout := mode.FileType() == linux.ModeCharacterDevice && major == 0 && minor == 0
+		if !isWhiteout && !t.HasCapability(linux.CAP_MKNOD) {
+			return syserror.EPERM
+		}
+	}
 	return t.Kernel().VFS().MknodAt(t, t.Credentials(), &tpop.pop, &vfs.MknodOptions{
 		Mode:     mode &^ linux.FileMode(t.FSContext().Umask()),
 		DevMajor: uint32(major),
@@ -218,6 +227,17 @@ func Renameat2(t *kernel.Task, args arch.SyscallArguments) (uintptr, *kernel.Sys
 }
 
 func renameat(t *kernel.Task, olddirfd int32, oldpathAddr usermem.Addr, newdirfd int32, newpathAddr usermem.Addr, flags uint32) error {
+	// Compare Linux's fs/namei.c:do_renameat2().
+	if flags&^(linux.RENAME_NOREPLACE|linux.RENAME_EXCHANGE|linux.RENAME_WHITEOUT) != 0 {
+		return syserror.EINVAL
+	}
+	if flags&linux.RENAME_EXCHANGE != 0 && flags&(linux.RENAME_NOREPLACE|linux.RENAME_WHITEOUT) != 0 {
+		return syserror.EINVAL
+	}
+	if flags&linux.RENAME_WHITEOUT != 0 && !t.HasCapabilityIn(linux.CAP_MKNOD, t.Kernel().RootUserNamespace()) {
+		return syserror.EPERM
+	}
+
 	oldpath, err := copyInPath(t, oldpathAddr)
 	if err != nil {
 		return err
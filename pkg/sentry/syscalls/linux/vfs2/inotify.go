@@ -116,7 +116,15 @@ func InotifyAddWatch(t *kernel.Task, args arch.SyscallArguments) (uintptr, *kern
 	}
 	defer d.DecRef(t)
 
-	fd, err = ino.AddWatch(d.Dentry(), mask)
+	stat, err := t.Kernel().VFS().StatAt(t, t.Credentials(), &vfs.PathOperation{
+		Root:  d,
+		Start: d,
+	}, &vfs.StatOptions{Mask: linux.STATX_INO})
+	if err != nil {
+		return 0, nil, err
+	}
+
+	fd, err = ino.AddWatch(d.Dentry(), &stat, mask)
 	if err != nil {
 		return 0, nil, err
 	}
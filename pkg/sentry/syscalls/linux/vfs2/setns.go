@@ -0,0 +1,58 @@
+// Copyright 2021 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vfs2
+
+import (
+	"gvisor.dev/gvisor/pkg/abi/linux"
+	"gvisor.dev/gvisor/pkg/sentry/arch"
+	"gvisor.dev/gvisor/pkg/sentry/inet"
+	"gvisor.dev/gvisor/pkg/sentry/kernel"
+	"gvisor.dev/gvisor/pkg/syserror"
+)
+
+// Setns implements linux syscall setns(2). Only network namespaces can be
+// joined.
+func Setns(t *kernel.Task, args arch.SyscallArguments) (uintptr, *kernel.SyscallControl, error) {
+	fd := args[0].Int()
+	nstype := args[1].Int()
+
+	file := t.GetFileVFS2(fd)
+	if file == nil {
+		return 0, nil, syserror.EBADF
+	}
+	defer file.DecRef(t)
+
+	nsFile, ok := file.Impl().(inet.NamespaceFile)
+	if !ok {
+		return 0, nil, syserror.EINVAL
+	}
+	ns := nsFile.NetworkNamespace()
+	if ns == nil {
+		// TODO(gvisor.dev/issue/140): Support joining other namespaces.
+		return 0, nil, syserror.EINVAL
+	}
+	if nstype != 0 && nstype != linux.CLONE_NEWNET {
+		return 0, nil, syserror.EINVAL
+	}
+	// Joining a network namespace requires CAP_SYS_ADMIN both in the caller's
+	// user namespace and in the one that owns the network namespace. See
+	// net/core/net_namespace.c:netns_install().
+	if !t.HasCapability(linux.CAP_SYS_ADMIN) || !t.HasCapabilityIn(linux.CAP_SYS_ADMIN, ns.UserNamespace()) {
+		return 0, nil, syserror.EPERM
+	}
+
+	t.SetNetworkNamespace(ns)
+	return 0, nil, nil
+}
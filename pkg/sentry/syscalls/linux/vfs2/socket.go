@@ -662,9 +662,13 @@ func RecvMMsg(t *kernel.Task, args arch.SyscallArguments) (uintptr, *kernel.Sysc
 	}
 
 	// Reject flags that we don't handle yet.
-	if flags & ^(baseRecvFlags|linux.MSG_CMSG_CLOEXEC|linux.MSG_ERRQUEUE) != 0 {
+	if flags & ^(baseRecvFlags|linux.MSG_CMSG_CLOEXEC|linux.MSG_ERRQUEUE|linux.MSG_WAITFORONE) != 0 {
 		return 0, nil, syserror.EINVAL
 	}
+	// MSG_WAITFORONE only applies to recvmmsg(2) itself: the messages after
+	// the first one are received as with MSG_DONTWAIT.
+	waitForOne := flags&linux.MSG_WAITFORONE != 0
+	flags &^= linux.MSG_WAITFORONE
 
 	// Get socket from the file descriptor.
 	file := t.GetFileVFS2(fd)
@@ -727,6 +731,9 @@ func RecvMMsg(t *kernel.Task, args arch.SyscallArguments) (uintptr, *kernel.Sysc
 			break
 		}
 		count++
+		if waitForOne {
+			flags |= linux.MSG_DONTWAIT
+		}
 	}
 
 	if count == 0 {
@@ -918,7 +925,7 @@ func SendMsg(t *kernel.Task, args arch.SyscallArguments) (uintptr, *kernel.Sysca
 	}
 
 	// Reject flags that we don't handle yet.
-	if flags & ^(linux.MSG_DONTWAIT|linux.MSG_EOR|linux.MSG_MORE|linux.MSG_NOSIGNAL) != 0 {
+	if flags & ^(linux.MSG_DONTWAIT|linux.MSG_EOR|linux.MSG_MORE|linux.MSG_NOSIGNAL|linux.MSG_FASTOPEN|linux.MSG_ZEROCOPY) != 0 {
 		return 0, nil, syserror.EINVAL
 	}
 
@@ -956,7 +963,7 @@ func SendMMsg(t *kernel.Task, args arch.SyscallArguments) (uintptr, *kernel.Sysc
 	}
 
 	// Reject flags that we don't handle yet.
-	if flags & ^(linux.MSG_DONTWAIT|linux.MSG_EOR|linux.MSG_MORE|linux.MSG_NOSIGNAL) != 0 {
+	if flags & ^(linux.MSG_DONTWAIT|linux.MSG_EOR|linux.MSG_MORE|linux.MSG_NOSIGNAL|linux.MSG_FASTOPEN|linux.MSG_ZEROCOPY) != 0 {
 		return 0, nil, syserror.EINVAL
 	}
 
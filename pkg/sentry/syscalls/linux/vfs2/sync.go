@@ -18,6 +18,7 @@ import (
 	"gvisor.dev/gvisor/pkg/abi/linux"
 	"gvisor.dev/gvisor/pkg/sentry/arch"
 	"gvisor.dev/gvisor/pkg/sentry/kernel"
+	"gvisor.dev/gvisor/pkg/sentry/vfs"
 	"gvisor.dev/gvisor/pkg/syserror"
 )
 
@@ -79,9 +80,20 @@ func SyncFileRange(t *kernel.Task, args arch.SyscallArguments) (uintptr, *kernel
 	}
 	defer file.DecRef(t)
 
-	// TODO(gvisor.dev/issue/1897): Currently, the only file syncing we support
-	// is a full-file sync, i.e. fsync(2). As a result, there are severe
-	// limitations on how much we support sync_file_range:
+	if ext, ok := file.Impl().(vfs.FileDescriptionImplSyncRangeExtension); ok {
+		// Cached data is written back synchronously, so there is never
+		// write-out in progress to wait for with WAIT_BEFORE or WAIT_AFTER.
+		if flags&linux.SYNC_FILE_RANGE_WRITE != 0 {
+			if err := ext.SyncRange(t, offset, nbytes); err != nil {
+				return 0, nil, syserror.ConvertIntr(err, syserror.ERESTARTSYS)
+			}
+		}
+		return 0, nil, nil
+	}
+
+	// TODO(gvisor.dev/issue/1897): For other files, the only file syncing we
+	// support is a full-file sync, i.e. fsync(2). As a result, there are
+	// severe limitations on how much we support sync_file_range:
 	// - In Linux, sync_file_range(2) doesn't write out the file's metadata, even
 	//   if the file size is changed. We do.
 	// - We always sync the entire file instead of [offset, offset+nbytes).
@@ -50,7 +50,7 @@ func TimerfdCreate(t *kernel.Task, args arch.SyscallArguments) (uintptr, *kernel
 		return 0, nil, syserror.EINVAL
 	}
 	vfsObj := t.Kernel().VFS()
-	file, err := timerfd.New(t, vfsObj, clock, fileFlags)
+	file, err := timerfd.New(t, vfsObj, clockID, clock, fileFlags)
 	if err != nil {
 		return 0, nil, err
 	}
@@ -94,7 +94,7 @@ func TimerfdSettime(t *kernel.Task, args arch.SyscallArguments) (uintptr, *kerne
 	if err != nil {
 		return 0, nil, err
 	}
-	tm, oldS := tfd.SetTime(newS)
+	tm, oldS := tfd.SetTime(newS, flags)
 	if oldValAddr != 0 {
 		oldVal := ktime.ItimerspecFromSetting(tm, oldS)
 		if _, err := oldVal.CopyOut(t, oldValAddr); err != nil {
@@ -153,6 +153,7 @@ func Override() {
 	s.Table[299] = syscalls.Supported("recvmmsg", RecvMMsg)
 	s.Table[306] = syscalls.Supported("syncfs", Syncfs)
 	s.Table[307] = syscalls.Supported("sendmmsg", SendMMsg)
+	s.Table[308] = syscalls.PartiallySupported("setns", Setns, "Only network namespaces are supported.", []string{"gvisor.dev/issue/140"})
 	s.Table[316] = syscalls.Supported("renameat2", Renameat2)
 	s.Table[319] = syscalls.Supported("memfd_create", MemfdCreate)
 	s.Table[322] = syscalls.Supported("execveat", Execveat)
@@ -262,6 +263,7 @@ func Override() {
 	s.Table[242] = syscalls.Supported("accept4", Accept4)
 	s.Table[243] = syscalls.Supported("recvmmsg", RecvMMsg)
 	s.Table[267] = syscalls.Supported("syncfs", Syncfs)
+	s.Table[268] = syscalls.PartiallySupported("setns", Setns, "Only network namespaces are supported.", []string{"gvisor.dev/issue/140"})
 	s.Table[269] = syscalls.Supported("sendmmsg", SendMMsg)
 	s.Table[276] = syscalls.Supported("renameat2", Renameat2)
 	s.Table[279] = syscalls.Supported("memfd_create", MemfdCreate)
@@ -253,14 +253,16 @@ func (m *MemoryLocked) Copy() (MemoryStats, uint64) {
 }
 
 // These options control how much total memory the is reported to the application.
-// They may only be set before the application starts executing, and must not
-// be modified.
 var (
 	// MinimumTotalMemoryBytes is the minimum reported total system memory.
+	// It may only be set before the application starts executing, and must
+	// not be modified.
 	MinimumTotalMemoryBytes uint64 = 2 << 30 // 2 GB
 
 	// MaximumTotalMemoryBytes is the maximum reported total system memory.
-	// The 0 value indicates no maximum.
+	// The 0 value indicates no maximum. It may be changed while the
+	// application is running, so it must be accessed using atomic memory
+	// operations.
 	MaximumTotalMemoryBytes uint64
 )
 
@@ -284,8 +286,8 @@ func TotalMemory(memSize, used uint64) uint64 {
 			memSize = uint64(1) << (uint(msb) + 1)
 		}
 	}
-	if MaximumTotalMemoryBytes > 0 && memSize > MaximumTotalMemoryBytes {
-		memSize = MaximumTotalMemoryBytes
+	if max := atomic.LoadUint64(&MaximumTotalMemoryBytes); max > 0 && memSize > max {
+		memSize = max
 	}
 	return memSize
 }
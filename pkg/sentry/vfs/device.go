@@ -16,6 +16,7 @@ package vfs
 
 import (
 	"fmt"
+	"sort"
 
 	"gvisor.dev/gvisor/pkg/context"
 	"gvisor.dev/gvisor/pkg/syserror"
@@ -74,6 +75,15 @@ type RegisterDeviceOptions struct {
 	// /proc/devices. If GroupName is empty, this registration will not be
 	// shown in /proc/devices.
 	GroupName string
+
+	// Pathname is the path of the device special file representing this
+	// device, relative to the root of devtmpfs. If Pathname is empty, no
+	// device special file is created for this device.
+	Pathname string
+
+	// FilePerms are the permissions of the device special file. They are only
+	// used if Pathname is not empty.
+	FilePerms uint16
 }
 
 // RegisterDevice registers the given Device in vfs with the given major and
@@ -92,6 +102,43 @@ func (vfs *VirtualFilesystem) RegisterDevice(kind DeviceKind, major, minor uint3
 	return nil
 }
 
+// ForEachDevice calls cb for each registered Device with the options it was
+// registered with. It stops at the first error returned by cb, and returns
+// it.
+//
+// cb is not called with vfs.devicesMu locked, so it may register devices.
+func (vfs *VirtualFilesystem) ForEachDevice(cb func(kind DeviceKind, major, minor uint32, opts *RegisterDeviceOptions) error) error {
+	type device struct {
+		tup  devTuple
+		opts RegisterDeviceOptions
+	}
+	vfs.devicesMu.RLock()
+	devices := make([]device, 0, len(vfs.devices))
+	for tup, rd := range vfs.devices {
+		devices = append(devices, device{tup, rd.opts})
+	}
+	vfs.devicesMu.RUnlock()
+
+	// Sort devices, so that cb is called in a deterministic order.
+	sort.Slice(devices, func(i, j int) bool {
+		a, b := devices[i].tup, devices[j].tup
+		if a.kind != b.kind {
+			return a.kind < b.kind
+		}
+		if a.major != b.major {
+			return a.major < b.major
+		}
+		return a.minor < b.minor
+	})
+	for i := range devices {
+		d := &devices[i]
+		if err := cb(d.tup.kind, d.tup.major, d.tup.minor, &d.opts); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 // OpenDeviceSpecialFile returns a FileDescription representing the given
 // device.
 func (vfs *VirtualFilesystem) OpenDeviceSpecialFile(ctx context.Context, mnt *Mount, d *Dentry, kind DeviceKind, major, minor uint32, opts *OpenOptions) (*FileDescription, error) {
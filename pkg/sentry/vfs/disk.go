@@ -0,0 +1,131 @@
+// Copyright 2021 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vfs
+
+import (
+	"io"
+
+	"gvisor.dev/gvisor/pkg/abi/linux"
+	"gvisor.dev/gvisor/pkg/context"
+	"gvisor.dev/gvisor/pkg/fspath"
+	"gvisor.dev/gvisor/pkg/sentry/kernel/auth"
+	"gvisor.dev/gvisor/pkg/syserror"
+)
+
+// A Disk is the storage backing a block device. Filesystems can be mounted
+// from Disks.
+type Disk interface {
+	// ReadAt reads len(p) bytes from the disk at offset off, with the
+	// semantics of io.ReaderAt.ReadAt. I/O is done on behalf of ctx.
+	ReadAt(ctx context.Context, p []byte, off int64) (int, error)
+
+	// WriteAt writes p to the disk at offset off, with the semantics of
+	// io.WriterAt.WriteAt. I/O is done on behalf of ctx.
+	WriteAt(ctx context.Context, p []byte, off int64) (int, error)
+
+	// Size returns the size of the disk in bytes.
+	Size() int64
+
+	// ReadOnly returns true if the disk can't be written.
+	ReadOnly() bool
+
+	// DecRef releases the reference on the disk returned by DiskDevice.Disk.
+	DecRef(ctx context.Context)
+}
+
+// DiskIO adapts a Disk to io.ReaderAt and io.WriterAt, for users such as
+// filesystem image readers that don't pass a context with each operation.
+type DiskIO struct {
+	// Ctx is the context that all I/O is done on behalf of.
+	Ctx context.Context
+
+	// Disk is the adapted disk.
+	Disk Disk
+}
+
+var _ io.ReaderAt = DiskIO{}
+var _ io.WriterAt = DiskIO{}
+
+// ReadAt implements io.ReaderAt.ReadAt.
+func (d DiskIO) ReadAt(p []byte, off int64) (int, error) {
+	return d.Disk.ReadAt(d.Ctx, p, off)
+}
+
+// WriteAt implements io.WriterAt.WriteAt.
+func (d DiskIO) WriteAt(p []byte, off int64) (int, error) {
+	return d.Disk.WriteAt(d.Ctx, p, off)
+}
+
+// A DiskDevice is a block Device backed by a Disk.
+type DiskDevice interface {
+	Device
+
+	// Disk returns the disk backing the device, with a reference held by the
+	// caller. Disk returns ENXIO if the device has no backing disk, e.g.
+	// because it is an unbound loop device.
+	Disk(ctx context.Context) (Disk, error)
+}
+
+// GetDiskAt returns the Disk backing the block device special file at pop,
+// with a reference held by the caller.
+func (vfs *VirtualFilesystem) GetDiskAt(ctx context.Context, creds *auth.Credentials, pop *PathOperation) (Disk, error) {
+	stat, err := vfs.StatAt(ctx, creds, pop, &StatOptions{Mask: linux.STATX_TYPE})
+	if err != nil {
+		return nil, err
+	}
+	if stat.Mode&linux.S_IFMT != linux.S_IFBLK {
+		return nil, syserror.ENOTBLK
+	}
+	return vfs.GetDisk(ctx, stat.RdevMajor, stat.RdevMinor)
+}
+
+// GetDisk returns the Disk backing the block device with the given device
+// numbers, with a reference held by the caller.
+func (vfs *VirtualFilesystem) GetDisk(ctx context.Context, major, minor uint32) (Disk, error) {
+	tup := devTuple{BlockDevice, major, minor}
+	vfs.devicesMu.RLock()
+	rd, ok := vfs.devices[tup]
+	vfs.devicesMu.RUnlock()
+	if !ok {
+		return nil, syserror.ENXIO
+	}
+	dd, ok := rd.dev.(DiskDevice)
+	if !ok {
+		return nil, syserror.ENXIO
+	}
+	return dd.Disk(ctx)
+}
+
+// GetDiskFromSource returns the Disk backing the block device special file
+// named by the source of a mount, with a reference held by the caller. The
+// source must be an absolute path, which is resolved from the root of the
+// mounting task.
+func (vfs *VirtualFilesystem) GetDiskFromSource(ctx context.Context, creds *auth.Credentials, source string) (Disk, error) {
+	path := fspath.Parse(source)
+	if !path.Absolute {
+		return nil, syserror.ENOTBLK
+	}
+	root := RootFromContext(ctx)
+	if !root.Ok() {
+		return nil, syserror.ENOTBLK
+	}
+	defer root.DecRef(ctx)
+	return vfs.GetDiskAt(ctx, creds, &PathOperation{
+		Root:               root,
+		Start:              root,
+		Path:               path,
+		FollowFinalSymlink: true,
+	})
+}
@@ -15,6 +15,10 @@
 package vfs
 
 import (
+	"bytes"
+	"fmt"
+	"sort"
+
 	"gvisor.dev/gvisor/pkg/abi/linux"
 	"gvisor.dev/gvisor/pkg/context"
 	"gvisor.dev/gvisor/pkg/sync"
@@ -162,6 +166,50 @@ func (ep *EpollInstance) Seek(ctx context.Context, offset int64, whence int32) (
 	return 0, nil
 }
 
+// FDInfo implements FileDescriptionImplFDInfoExtension.FDInfo. It shows each
+// registered file descriptor, as in Linux's fs/eventpoll.c:ep_show_fdinfo().
+func (ep *EpollInstance) FDInfo(ctx context.Context, buf *bytes.Buffer) {
+	type target struct {
+		file *FileDescription
+		num  int32
+		mask uint32
+		data uint64
+	}
+	ep.interestMu.Lock()
+	ep.mu.Lock()
+	targets := make([]target, 0, len(ep.interest))
+	for key, epi := range ep.interest {
+		// The file may be concurrently released, in which case it is about to
+		// be unregistered.
+		if !key.file.TryIncRef() {
+			continue
+		}
+		targets = append(targets, target{
+			file: key.file,
+			num:  key.num,
+			mask: epi.mask,
+			data: uint64(uint32(epi.userData[0])) | uint64(uint32(epi.userData[1]))<<32,
+		})
+	}
+	ep.mu.Unlock()
+	ep.interestMu.Unlock()
+
+	sort.Slice(targets, func(i, j int) bool { return targets[i].num < targets[j].num })
+	for _, t := range targets {
+		// Files without an offset or inode number show 0, as in Linux.
+		pos, err := t.file.Seek(ctx, 0, linux.SEEK_CUR)
+		if err != nil {
+			pos = 0
+		}
+		stat, err := t.file.Stat(ctx, StatOptions{Mask: linux.STATX_INO})
+		if err != nil {
+			stat = linux.Statx{}
+		}
+		fmt.Fprintf(buf, "tfd: %8d events: %8x data: %16x  pos:%d ino:%x sdev:%x\n", t.num, t.mask, t.data, pos, stat.Ino, fdInfoDeviceID(stat.DevMajor, stat.DevMinor))
+		t.file.DecRef(ctx)
+	}
+}
+
 // AddInterest implements the semantics of EPOLL_CTL_ADD.
 //
 // Preconditions: A reference must be held on file.
@@ -15,6 +15,7 @@
 package vfs
 
 import (
+	"bytes"
 	"io"
 	"sync/atomic"
 
@@ -520,7 +521,10 @@ func (fd *FileDescription) Stat(ctx context.Context, opts StatOptions) (linux.St
 		vfsObj.putResolvingPath(ctx, rp)
 		return stat, err
 	}
-	return fd.impl.Stat(ctx, opts)
+	start := startMountOp()
+	stat, err := fd.impl.Stat(ctx, opts)
+	finishMountOp(ctx, fd.vd, MountOpStat, start, 0)
+	return stat, err
 }
 
 // SetStat updates metadata for the file represented by fd.
@@ -594,9 +598,11 @@ func (fd *FileDescription) PRead(ctx context.Context, dst usermem.IOSequence, of
 		return 0, syserror.EBADF
 	}
 	start := fsmetric.StartReadWait()
+	opStart := startMountOp()
 	n, err := fd.impl.PRead(ctx, dst, offset, opts)
 	fsmetric.Reads.Increment()
 	fsmetric.FinishReadWait(fsmetric.ReadWait, start)
+	finishMountOp(ctx, fd.vd, MountOpRead, opStart, n)
 	return n, err
 }
 
@@ -606,9 +612,11 @@ func (fd *FileDescription) Read(ctx context.Context, dst usermem.IOSequence, opt
 		return 0, syserror.EBADF
 	}
 	start := fsmetric.StartReadWait()
+	opStart := startMountOp()
 	n, err := fd.impl.Read(ctx, dst, opts)
 	fsmetric.Reads.Increment()
 	fsmetric.FinishReadWait(fsmetric.ReadWait, start)
+	finishMountOp(ctx, fd.vd, MountOpRead, opStart, n)
 	return n, err
 }
 
@@ -622,7 +630,10 @@ func (fd *FileDescription) PWrite(ctx context.Context, src usermem.IOSequence, o
 	if !fd.writable {
 		return 0, syserror.EBADF
 	}
-	return fd.impl.PWrite(ctx, src, offset, opts)
+	start := startMountOp()
+	n, err := fd.impl.PWrite(ctx, src, offset, opts)
+	finishMountOp(ctx, fd.vd, MountOpWrite, start, n)
+	return n, err
 }
 
 // Write is similar to PWrite, but does not specify an offset.
@@ -630,14 +641,20 @@ func (fd *FileDescription) Write(ctx context.Context, src usermem.IOSequence, op
 	if !fd.writable {
 		return 0, syserror.EBADF
 	}
-	return fd.impl.Write(ctx, src, opts)
+	start := startMountOp()
+	n, err := fd.impl.Write(ctx, src, opts)
+	finishMountOp(ctx, fd.vd, MountOpWrite, start, n)
+	return n, err
 }
 
 // IterDirents invokes cb on each entry in the directory represented by fd. If
 // IterDirents has been called since the last call to Seek, it continues
 // iteration from the end of the last call.
 func (fd *FileDescription) IterDirents(ctx context.Context, cb IterDirentsCallback) error {
-	return fd.impl.IterDirents(ctx, cb)
+	start := startMountOp()
+	err := fd.impl.IterDirents(ctx, cb)
+	finishMountOp(ctx, fd.vd, MountOpGetdents, start, 0)
+	return err
 }
 
 // Seek changes fd's offset (assuming one exists) and returns its new value.
@@ -647,7 +664,40 @@ func (fd *FileDescription) Seek(ctx context.Context, offset int64, whence int32)
 
 // Sync has the semantics of fsync(2).
 func (fd *FileDescription) Sync(ctx context.Context) error {
-	return fd.impl.Sync(ctx)
+	start := startMountOp()
+	err := fd.impl.Sync(ctx)
+	finishMountOp(ctx, fd.vd, MountOpFsync, start, 0)
+	return err
+}
+
+// FileDescriptionImplSyncRangeExtension is an optional extension to
+// FileDescriptionImpl, implemented by files that can write back cached data
+// in part of the file without syncing the whole file.
+type FileDescriptionImplSyncRangeExtension interface {
+	// SyncRange writes back cached data in the range [offset, offset+length)
+	// of the file, or [offset, EOF) if length is 0, and blocks until this is
+	// complete. Unlike Sync, SyncRange doesn't write back metadata or request
+	// that written data reaches persistent storage. Compare
+	// sync_file_range(2).
+	SyncRange(ctx context.Context, offset, length int64) error
+}
+
+// FileDescriptionImplFDInfoExtension is an optional extension to
+// FileDescriptionImpl, implemented by files that show information specific to
+// their type in /proc/[pid]/fdinfo/[fd], like Linux's
+// file_operations::show_fdinfo.
+type FileDescriptionImplFDInfoExtension interface {
+	// FDInfo writes lines describing the file to buf. They follow the fields
+	// common to all files.
+	FDInfo(ctx context.Context, buf *bytes.Buffer)
+}
+
+// fdInfoDeviceID returns the device number with the given major and minor
+// numbers as shown in fdinfo files, which is the Linux kernel's internal
+// representation of device numbers (include/linux/kdev_t.h:MKDEV) rather than
+// the one used by stat(2).
+func fdInfoDeviceID(major, minor uint32) uint32 {
+	return major<<20 | minor
 }
 
 // ConfigureMMap mutates opts to implement mmap(2) for the file represented by
@@ -17,6 +17,7 @@ package vfs
 import (
 	"bytes"
 	"fmt"
+	"sort"
 	"sync/atomic"
 
 	"gvisor.dev/gvisor/pkg/abi/linux"
@@ -292,12 +293,14 @@ func (i *Inotify) queueEvent(ev *Event) {
 // newWatchLocked creates and adds a new watch to target.
 //
 // Precondition: i.mu must be locked. ws must be the watch set for target d.
-func (i *Inotify) newWatchLocked(d *Dentry, ws *Watches, mask uint32) *Watch {
+func (i *Inotify) newWatchLocked(d *Dentry, stat *linux.Statx, ws *Watches, mask uint32) *Watch {
 	w := &Watch{
-		owner:  i,
-		wd:     i.nextWatchIDLocked(),
-		target: d,
-		mask:   mask,
+		owner:     i,
+		wd:        i.nextWatchIDLocked(),
+		target:    d,
+		targetIno: stat.Ino,
+		targetDev: fdInfoDeviceID(stat.DevMajor, stat.DevMinor),
+		mask:      mask,
 	}
 
 	// Hold the watch in this inotify instance as well as the watch set on the
@@ -316,10 +319,11 @@ func (i *Inotify) nextWatchIDLocked() int32 {
 }
 
 // AddWatch constructs a new inotify watch and adds it to the target. It
-// returns the watch descriptor returned by inotify_add_watch(2).
+// returns the watch descriptor returned by inotify_add_watch(2). stat must
+// contain the inode number and device of target, which are shown in fdinfo.
 //
 // The caller must hold a reference on target.
-func (i *Inotify) AddWatch(target *Dentry, mask uint32) (int32, error) {
+func (i *Inotify) AddWatch(target *Dentry, stat *linux.Statx, mask uint32) (int32, error) {
 	// Note: Locking this inotify instance protects the result returned by
 	// Lookup() below. With the lock held, we know for sure the lookup result
 	// won't become stale because it's impossible for *this* instance to
@@ -346,10 +350,28 @@ func (i *Inotify) AddWatch(target *Dentry, mask uint32) (int32, error) {
 	}
 
 	// No existing watch, create a new watch.
-	w := i.newWatchLocked(target, ws, mask)
+	w := i.newWatchLocked(target, stat, ws, mask)
 	return w.wd, nil
 }
 
+// FDInfo implements FileDescriptionImplFDInfoExtension.FDInfo. It shows each
+// watch, as in Linux's fs/notify/fdinfo.c:inotify_show_fdinfo().
+func (i *Inotify) FDInfo(ctx context.Context, buf *bytes.Buffer) {
+	i.mu.Lock()
+	watches := make([]*Watch, 0, len(i.watches))
+	for _, w := range i.watches {
+		watches = append(watches, w)
+	}
+	i.mu.Unlock()
+
+	sort.Slice(watches, func(i, j int) bool { return watches[i].wd < watches[j].wd })
+	for _, w := range watches {
+		// Linux only shows the events in the mask, not the flags.
+		mask := atomic.LoadUint32(&w.mask) & linux.IN_ALL_EVENTS
+		fmt.Fprintf(buf, "inotify wd:%x ino:%x sdev:%x mask:%x ignored_mask:0\n", w.wd, w.targetIno, w.targetDev, mask)
+	}
+}
+
 // RmWatch looks up an inotify watch for the given 'wd' and configures the
 // target to stop sending events to this inotify instance.
 func (i *Inotify) RmWatch(ctx context.Context, wd int32) error {
@@ -556,6 +578,13 @@ type Watch struct {
 	// This field is immutable after creation.
 	target *Dentry
 
+	// targetIno and targetDev are the inode number and device of target, as
+	// shown in fdinfo.
+	//
+	// These fields are immutable after creation.
+	targetIno uint64
+	targetDev uint32
+
 	// Events being monitored via this watch. Must be accessed with atomic
 	// memory operations.
 	mask uint32
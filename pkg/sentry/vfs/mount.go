@@ -92,6 +92,10 @@ type Mount struct {
 	// Mount.EndWrite(). The MSB of writers is set if MS_RDONLY is in effect.
 	// writers is accessed using atomic memory operations.
 	writers int64
+
+	// stats holds file operation statistics for the Mount, if
+	// RecordMountStats is true.
+	stats mountStats `state:"nosave"`
 }
 
 func newMount(vfs *VirtualFilesystem, fs *Filesystem, root *Dentry, mntns *MountNamespace, opts *MountOptions) *Mount {
@@ -0,0 +1,237 @@
+// Copyright 2020 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vfs
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"sync/atomic"
+	"time"
+
+	"gvisor.dev/gvisor/pkg/context"
+	"gvisor.dev/gvisor/pkg/metric"
+)
+
+// RecordMountStats enables the collection of per-mount file operation
+// statistics, reported by VirtualFilesystem.GenerateMountStats() and, summed
+// over all mounts, by metrics. Enabling this comes at a CPU cost due to
+// performing two clock reads per file operation.
+//
+// RecordMountStats must not change after file operations have started.
+var RecordMountStats = false
+
+// SlowOpThreshold, if non-zero, causes file operations that take at least
+// this long to be logged.
+//
+// SlowOpThreshold must not change after file operations have started.
+var SlowOpThreshold time.Duration
+
+// MountOp is a kind of file operation for which per-mount statistics are
+// collected.
+type MountOp int
+
+// MountOp values.
+const (
+	MountOpOpen MountOp = iota
+	MountOpStat
+	MountOpRead
+	MountOpWrite
+	MountOpGetdents
+	MountOpFsync
+
+	// numMountOps must be last.
+	numMountOps
+)
+
+var mountOpNames = [numMountOps]string{
+	MountOpOpen:     "open",
+	MountOpStat:     "stat",
+	MountOpRead:     "read",
+	MountOpWrite:    "write",
+	MountOpGetdents: "getdents",
+	MountOpFsync:    "fsync",
+}
+
+// String implements fmt.Stringer.String.
+func (op MountOp) String() string {
+	if op < 0 || op >= numMountOps {
+		return fmt.Sprintf("MountOp(%d)", int(op))
+	}
+	return mountOpNames[op]
+}
+
+// mountOpLatencyBuckets are the upper bounds of the buckets of the per-mount
+// latency histograms. The last bucket of each histogram counts operations
+// that took longer than all of these.
+var mountOpLatencyBuckets = [...]time.Duration{
+	10 * time.Microsecond,
+	100 * time.Microsecond,
+	time.Millisecond,
+	10 * time.Millisecond,
+	100 * time.Millisecond,
+	time.Second,
+}
+
+// mountOpMetrics are the metrics for each MountOp, summed over all mounts.
+var mountOpMetrics [numMountOps]struct {
+	ops   *metric.Uint64Metric
+	wait  *metric.Uint64Metric
+	bytes *metric.Uint64Metric
+}
+
+func init() {
+	for op := MountOp(0); op < numMountOps; op++ {
+		m := &mountOpMetrics[op]
+		m.ops = metric.MustCreateNewUint64Metric(fmt.Sprintf("/fs/mount_stats/%s_ops", op), false /* sync */, fmt.Sprintf("Number of %s operations, if mount statistics are enabled.", op))
+		m.wait = metric.MustCreateNewUint64NanosecondsMetric(fmt.Sprintf("/fs/mount_stats/%s_wait", op), false /* sync */, fmt.Sprintf("Time spent in %s operations, in nanoseconds, if mount statistics are enabled.", op))
+		if op == MountOpRead || op == MountOpWrite {
+			m.bytes = metric.MustCreateNewUint64Metric(fmt.Sprintf("/fs/mount_stats/%s_bytes", op), false /* sync */, fmt.Sprintf("Number of bytes transferred by %s operations, if mount statistics are enabled.", op))
+		}
+	}
+}
+
+// mountStats holds the file operation statistics of a Mount. Its fields are
+// accessed using atomic memory operations.
+type mountStats struct {
+	ops [numMountOps]mountOpStats
+}
+
+// mountOpStats holds the statistics of a MountOp on a Mount.
+type mountOpStats struct {
+	count   uint64
+	bytes   uint64
+	totalNS uint64
+	latency [len(mountOpLatencyBuckets) + 1]uint64
+}
+
+func (s *mountOpStats) record(d time.Duration, bytes int64) {
+	atomic.AddUint64(&s.count, 1)
+	if bytes > 0 {
+		atomic.AddUint64(&s.bytes, uint64(bytes))
+	}
+	atomic.AddUint64(&s.totalNS, uint64(d))
+	i := sort.Search(len(mountOpLatencyBuckets), func(i int) bool {
+		return d < mountOpLatencyBuckets[i]
+	})
+	atomic.AddUint64(&s.latency[i], 1)
+}
+
+// startMountOp returns the time at which a file operation began, or the zero
+// time if file operations aren't being timed. The result must be passed to
+// finishMountOp when the operation completes.
+func startMountOp() time.Time {
+	if !RecordMountStats && SlowOpThreshold == 0 {
+		return time.Time{}
+	}
+	return time.Now()
+}
+
+// finishMountOp records the completion of op on the file at vd, which began
+// at start and transferred the given number of bytes.
+func finishMountOp(ctx context.Context, vd VirtualDentry, op MountOp, start time.Time, bytes int64) {
+	if start.IsZero() || vd.mount == nil {
+		return
+	}
+	d := time.Since(start)
+	vd.mount.recordOp(op, d, bytes)
+	if isSlowOp(d) {
+		root := RootFromContext(ctx)
+		if root.Ok() {
+			defer root.DecRef(ctx)
+		}
+		name, err := vd.mount.vfs.PathnameWithDeleted(ctx, root, vd)
+		if err != nil {
+			name = fmt.Sprintf("<unknown: %v>", err)
+		}
+		logSlowOp(ctx, vd.mount, op, name, d)
+	}
+}
+
+// finishMountPathOp records the completion of op on the file at pop, which
+// began at start and was performed by mnt.
+func finishMountPathOp(ctx context.Context, mnt *Mount, pop *PathOperation, op MountOp, start time.Time) {
+	if start.IsZero() {
+		return
+	}
+	d := time.Since(start)
+	mnt.recordOp(op, d, 0)
+	if isSlowOp(d) {
+		logSlowOp(ctx, mnt, op, pop.Path.String(), d)
+	}
+}
+
+func (mnt *Mount) recordOp(op MountOp, d time.Duration, bytes int64) {
+	if !RecordMountStats {
+		return
+	}
+	mnt.stats.ops[op].record(d, bytes)
+	m := &mountOpMetrics[op]
+	m.ops.Increment()
+	m.wait.IncrementBy(uint64(d))
+	if m.bytes != nil && bytes > 0 {
+		m.bytes.IncrementBy(uint64(bytes))
+	}
+}
+
+func isSlowOp(d time.Duration) bool {
+	return SlowOpThreshold != 0 && d >= SlowOpThreshold
+}
+
+func logSlowOp(ctx context.Context, mnt *Mount, op MountOp, name string, d time.Duration) {
+	ctx.Warningf("Slow %s of %q (%s mount %d) took %v", op, name, mnt.fs.FilesystemType().Name(), mnt.ID, d)
+}
+
+// GenerateMountStats emits the file operation statistics of mounts reachable
+// from root to buf.
+//
+// Preconditions: root.Ok().
+func (vfs *VirtualFilesystem) GenerateMountStats(ctx context.Context, root VirtualDentry, buf *bytes.Buffer) {
+	vfs.mountMu.Lock()
+	mounts := root.mount.submountsLocked()
+	// Take a reference on mounts since we need to drop vfs.mountMu before
+	// calling vfs.PathnameReachable() (=> FilesystemImpl.PrependPath()).
+	for _, mnt := range mounts {
+		mnt.IncRef()
+	}
+	vfs.mountMu.Unlock()
+	defer func() {
+		for _, mnt := range mounts {
+			mnt.DecRef(ctx)
+		}
+	}()
+	sort.Slice(mounts, func(i, j int) bool { return mounts[i].ID < mounts[j].ID })
+
+	for _, mnt := range mounts {
+		path, err := vfs.PathnameReachable(ctx, root, VirtualDentry{mount: mnt, dentry: mnt.root})
+		if err != nil || path == "" {
+			// The mount isn't reachable from root.
+			continue
+		}
+		fmt.Fprintf(buf, "mount %d %s (%s):\n", mnt.ID, path, mnt.fs.FilesystemType().Name())
+		for op := MountOp(0); op < numMountOps; op++ {
+			s := &mnt.stats.ops[op]
+			count := atomic.LoadUint64(&s.count)
+			if count == 0 {
+				continue
+			}
+			fmt.Fprintf(buf, "\t%s: %d ops, %d bytes, %v total; latency", op, count, atomic.LoadUint64(&s.bytes), time.Duration(atomic.LoadUint64(&s.totalNS)))
+			for i, bound := range mountOpLatencyBuckets {
+				fmt.Fprintf(buf, " <%v:%d", bound, atomic.LoadUint64(&s.latency[i]))
+			}
+			fmt.Fprintf(buf, " >=%v:%d\n", mountOpLatencyBuckets[len(mountOpLatencyBuckets)-1], atomic.LoadUint64(&s.latency[len(mountOpLatencyBuckets)]))
+		}
+	}
+}
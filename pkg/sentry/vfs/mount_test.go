@@ -18,6 +18,7 @@ import (
 	"fmt"
 	"runtime"
 	"testing"
+	"time"
 
 	"gvisor.dev/gvisor/pkg/sync"
 )
@@ -33,6 +34,25 @@ func TestMountTableLookupEmpty(t *testing.T) {
 	}
 }
 
+func TestMountOpStatsRecord(t *testing.T) {
+	var s mountOpStats
+	s.record(time.Microsecond, 10)
+	s.record(10*time.Microsecond, 0)
+	s.record(5*time.Millisecond, 20)
+	s.record(time.Minute, -1)
+
+	if s.count != 4 {
+		t.Errorf("got count %d, want 4", s.count)
+	}
+	if s.bytes != 30 {
+		t.Errorf("got bytes %d, want 30", s.bytes)
+	}
+	want := [len(mountOpLatencyBuckets) + 1]uint64{1, 1, 0, 1, 0, 0, 1}
+	if s.latency != want {
+		t.Errorf("got latency histogram %v, want %v", s.latency, want)
+	}
+}
+
 func TestMountTableInsertLookup(t *testing.T) {
 	var mt mountTable
 	mt.Init()
@@ -383,6 +383,7 @@ func (vfs *VirtualFilesystem) MknodAt(ctx context.Context, creds *auth.Credentia
 // path. A reference is taken on the returned FileDescription.
 func (vfs *VirtualFilesystem) OpenAt(ctx context.Context, creds *auth.Credentials, pop *PathOperation, opts *OpenOptions) (*FileDescription, error) {
 	fsmetric.Opens.Increment()
+	start := startMountOp()
 
 	// Remove:
 	//
@@ -449,6 +450,7 @@ func (vfs *VirtualFilesystem) OpenAt(ctx context.Context, creds *auth.Credential
 			}
 
 			fd.Dentry().InotifyWithParent(ctx, linux.IN_OPEN, 0, PathEvent)
+			finishMountOp(ctx, fd.vd, MountOpOpen, start, 0)
 			return fd, nil
 		}
 		if !rp.handleError(ctx, err) {
@@ -586,10 +588,12 @@ func (vfs *VirtualFilesystem) SetStatAt(ctx context.Context, creds *auth.Credent
 
 // StatAt returns metadata for the file at the given path.
 func (vfs *VirtualFilesystem) StatAt(ctx context.Context, creds *auth.Credentials, pop *PathOperation, opts *StatOptions) (linux.Statx, error) {
+	start := startMountOp()
 	rp := vfs.getResolvingPath(creds, pop)
 	for {
 		stat, err := rp.mount.fs.impl.StatAt(ctx, rp, *opts)
 		if err == nil {
+			finishMountPathOp(ctx, rp.mount, pop, MountOpStat, start)
 			vfs.putResolvingPath(ctx, rp)
 			return stat, nil
 		}
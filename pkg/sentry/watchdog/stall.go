@@ -0,0 +1,126 @@
+// Copyright 2020 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package watchdog
+
+import (
+	"bytes"
+	"fmt"
+	"time"
+
+	"gvisor.dev/gvisor/pkg/metric"
+	"gvisor.dev/gvisor/pkg/sentry/kernel"
+)
+
+// maxStallEvents is the number of most recent stall events kept.
+const maxStallEvents = 16
+
+// Kinds of stall events.
+const (
+	// StallTask is reported when tasks are stuck.
+	StallTask = "task"
+
+	// StallStartup is reported when Watchdog.Start isn't called within
+	// Opts.StartupTimeout.
+	StallStartup = "startup"
+
+	// StallWatchdog is reported when the watchdog itself is stuck.
+	StallWatchdog = "watchdog"
+)
+
+// StallEvent describes a stall detected by the watchdog.
+type StallEvent struct {
+	// Seq is the sequence number of the event, starting at 1.
+	Seq uint64 `json:"seq"`
+
+	// Time is when the stall was detected.
+	Time time.Time `json:"time"`
+
+	// Kind is one of StallTask, StallStartup or StallWatchdog.
+	Kind string `json:"kind"`
+
+	// Message describes the stall.
+	Message string `json:"message"`
+
+	// Action is the action taken by the watchdog.
+	Action string `json:"action"`
+
+	// Tasks are the stuck tasks, for StallTask events.
+	Tasks []StalledTask `json:"tasks,omitempty"`
+}
+
+// StalledTask describes a stuck task.
+type StalledTask struct {
+	// TID is the ID of the task in the root PID namespace.
+	TID kernel.ThreadID `json:"tid"`
+
+	// GoroutineID is the ID of the task goroutine.
+	GoroutineID int64 `json:"goroutine_id"`
+
+	// Stalled is how long the task has been running in the sentry without
+	// blocking.
+	Stalled time.Duration `json:"stalled_ns"`
+
+	// Stack is the stack of the task goroutine.
+	Stack string `json:"stack,omitempty"`
+}
+
+// recordStall records a stall event, and emits the watchdog metrics so that
+// the stall is visible without waiting for the next metric update.
+func (w *Watchdog) recordStall(kind, msg string, tasks []StalledTask, action Action) {
+	w.eventsMu.Lock()
+	w.lastEventSeq++
+	w.events = append(w.events, StallEvent{
+		Seq:     w.lastEventSeq,
+		Time:    time.Now(),
+		Kind:    kind,
+		Message: msg,
+		Action:  action.String(),
+		Tasks:   tasks,
+	})
+	if len(w.events) > maxStallEvents {
+		w.events = w.events[len(w.events)-maxStallEvents:]
+	}
+	w.eventsMu.Unlock()
+
+	// The metric event channel may be stuck as well.
+	go metric.EmitMetricUpdate() // S/R-SAFE: watchdog is stopped and restarted during S/R.
+}
+
+// StallEvents returns the stall events recorded after the event numbered
+// after, oldest first. Only the most recent events are kept.
+func (w *Watchdog) StallEvents(after uint64) []StallEvent {
+	w.eventsMu.Lock()
+	defer w.eventsMu.Unlock()
+	var events []StallEvent
+	for _, e := range w.events {
+		if e.Seq > after {
+			events = append(events, e)
+		}
+	}
+	return events
+}
+
+// goroutineStacks splits stacks, as returned by runtime.Stack, by goroutine
+// ID.
+func goroutineStacks(stacks []byte) map[int64]string {
+	m := make(map[int64]string)
+	for _, s := range bytes.Split(stacks, []byte("\n\n")) {
+		var id int64
+		if _, err := fmt.Sscanf(string(s), "goroutine %d ", &id); err == nil {
+			m[id] = string(s)
+		}
+	}
+	return m
+}
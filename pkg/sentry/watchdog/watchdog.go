@@ -26,12 +26,18 @@
 //			 If a tasks continues to be stuck, the message will repeat every minute, unless
 //			 a new stuck task is detected
 //		2. Panic: same as above, followed by panic()
+//		3. CheckpointAndPanic: same as above, but the sandbox is checkpointed
+//			 before panicking, to allow inspecting its state
+//
+// Each time a task is detected to be stuck, a StallEvent with the stacks of
+// the stuck tasks is also recorded, and can be retrieved with StallEvents.
 //
 package watchdog
 
 import (
 	"bytes"
 	"fmt"
+	"sync/atomic"
 	"time"
 
 	"gvisor.dev/gvisor/pkg/abi/linux"
@@ -59,6 +65,10 @@ type Opts struct {
 	// StartupTimeoutAction indicates what action to take when
 	// watchdog.Start is not called within the timeout.
 	StartupTimeoutAction Action
+
+	// Checkpoint saves the sandbox for the CheckpointAndPanic action. It must
+	// not stop the watchdog, which waits for it to return.
+	Checkpoint func() error
 }
 
 // DefaultOpts is a default set of options for the watchdog.
@@ -77,11 +87,26 @@ var DefaultOpts = Opts{
 // trigger it.
 const descheduleThreshold = 1 * time.Second
 
+// checkpointTimeout is the amount of time the CheckpointAndPanic action waits
+// for the checkpoint before panicking. Checkpointing requires all tasks to be
+// paused, which stuck tasks may prevent.
+const checkpointTimeout = 1 * time.Minute
+
 var (
 	stuckStartup = metric.MustCreateNewUint64Metric("/watchdog/stuck_startup_detected", true /* sync */, "Incremented once on startup watchdog timeout")
 	stuckTasks   = metric.MustCreateNewUint64Metric("/watchdog/stuck_tasks_detected", true /* sync */, "Cumulative count of stuck tasks detected")
+
+	// stalledTasks is the number of tasks currently stuck. It is accessed
+	// using atomic memory operations.
+	stalledTasks uint64
 )
 
+func init() {
+	metric.MustRegisterCustomUint64Metric("/watchdog/stalled_tasks", false /* cumulative */, true /* sync */, "Number of tasks currently stuck", func() uint64 {
+		return atomic.LoadUint64(&stalledTasks)
+	})
+}
+
 // Amount of time to wait before dumping the stack to the log again when the same task(s) remains stuck.
 var stackDumpSameTaskPeriod = time.Minute
 
@@ -94,6 +119,10 @@ const (
 
 	// Panic will do the same logging as LogWarning and panic().
 	Panic
+
+	// CheckpointAndPanic will do the same logging as LogWarning, checkpoint
+	// the sandbox with Opts.Checkpoint and panic().
+	CheckpointAndPanic
 )
 
 // Set implements flag.Value.
@@ -103,6 +132,8 @@ func (a *Action) Set(v string) error {
 		*a = LogWarning
 	case "panic":
 		*a = Panic
+	case "checkpoint-then-panic":
+		*a = CheckpointAndPanic
 	default:
 		return fmt.Errorf("invalid watchdog action %q", v)
 	}
@@ -121,6 +152,8 @@ func (a *Action) String() string {
 		return "logWarning"
 	case Panic:
 		return "panic"
+	case CheckpointAndPanic:
+		return "checkpoint-then-panic"
 	default:
 		panic(fmt.Sprintf("Invalid watchdog action: %d", *a))
 	}
@@ -164,6 +197,15 @@ type Watchdog struct {
 	// startCalled is true if Start has ever been called. It remains true
 	// even if Stop is called.
 	startCalled bool
+
+	// eventsMu protects the fields below.
+	eventsMu sync.Mutex
+
+	// events are the most recent stall events, oldest first.
+	events []StallEvent
+
+	// lastEventSeq is the sequence number of the last stall event.
+	lastEventSeq uint64
 }
 
 type offender struct {
@@ -246,6 +288,7 @@ func (w *Watchdog) waitForStart() {
 
 	var buf bytes.Buffer
 	buf.WriteString(fmt.Sprintf("Watchdog.Start() not called within %s", w.StartupTimeout))
+	w.recordStall(StallStartup, buf.String(), nil, w.StartupTimeoutAction)
 	w.doAction(w.StartupTimeoutAction, false, &buf)
 }
 
@@ -328,6 +371,9 @@ func (w *Watchdog) runTurn() {
 
 	// Remember which tasks have been reported.
 	w.offenders = newOffenders
+	if old := atomic.SwapUint64(&stalledTasks, uint64(len(newOffenders))); old != uint64(len(newOffenders)) {
+		go metric.EmitMetricUpdate() // S/R-SAFE: watchdog is stopped and restarted during S/R.
+	}
 }
 
 // report takes appropriate action when a stuck task is detected.
@@ -340,6 +386,21 @@ func (w *Watchdog) report(offenders map[*kernel.Task]*offender, newTaskFound boo
 	}
 	buf.WriteString("Search for 'goroutine <id>' in the stack dump to find the offending goroutine(s)")
 
+	if newTaskFound {
+		stacks := goroutineStacks(log.Stacks(true))
+		var stalled []StalledTask
+		for t, o := range offenders {
+			goid := t.GoroutineID()
+			stalled = append(stalled, StalledTask{
+				TID:         w.k.TaskSet().Root.IDOfTask(t),
+				GoroutineID: goid,
+				Stalled:     now.Sub(o.lastUpdateTime),
+				Stack:       stacks[goid],
+			})
+		}
+		w.recordStall(StallTask, fmt.Sprintf("Sentry detected %d stuck task(s)", len(offenders)), stalled, w.TaskTimeoutAction)
+	}
+
 	// Force stack dump only if a new task is detected.
 	w.doAction(w.TaskTimeoutAction, newTaskFound, &buf)
 }
@@ -347,6 +408,7 @@ func (w *Watchdog) report(offenders map[*kernel.Task]*offender, newTaskFound boo
 func (w *Watchdog) reportStuckWatchdog() {
 	var buf bytes.Buffer
 	buf.WriteString("Watchdog goroutine is stuck")
+	w.recordStall(StallWatchdog, buf.String(), nil, w.TaskTimeoutAction)
 	w.doAction(w.TaskTimeoutAction, false, &buf)
 }
 
@@ -366,11 +428,15 @@ func (w *Watchdog) doAction(action Action, forceStack bool, msg *bytes.Buffer) {
 		log.TracebackAll(msg.String())
 		w.lastStackDump = time.Now()
 
-	case Panic:
+	case Panic, CheckpointAndPanic:
 		// Panic will skip over running tasks, which is likely the culprit here. So manually
 		// dump all stacks before panic'ing.
 		log.TracebackAll(msg.String())
 
+		if action == CheckpointAndPanic {
+			w.checkpoint()
+		}
+
 		// Attempt to flush metrics, timeout and move on in case metrics are stuck as well.
 		metricsEmitted := make(chan struct{}, 1)
 		go func() { // S/R-SAFE: watchdog is stopped during save and restarted after restore.
@@ -389,3 +455,27 @@ func (w *Watchdog) doAction(action Action, forceStack bool, msg *bytes.Buffer) {
 
 	}
 }
+
+// checkpoint saves the sandbox with w.Checkpoint, waiting for at most
+// checkpointTimeout.
+func (w *Watchdog) checkpoint() {
+	if w.Checkpoint == nil {
+		log.Warningf("Watchdog cannot checkpoint the sandbox: no checkpoint destination")
+		return
+	}
+	log.Infof("Watchdog checkpointing the sandbox")
+	done := make(chan error, 1)
+	go func() { // S/R-SAFE: the sentry panics after the checkpoint.
+		done <- w.Checkpoint()
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			log.Warningf("Watchdog checkpoint failed: %v", err)
+			return
+		}
+		log.Infof("Watchdog checkpoint succeeded")
+	case <-time.After(checkpointTimeout):
+		log.Warningf("Watchdog checkpoint did not complete within %v", checkpointTimeout)
+	}
+}
@@ -181,6 +181,22 @@ func (r *Runsc) Resume(context context.Context, id string) error {
 	return nil
 }
 
+// Update updates the resources of a container.
+func (r *Runsc) Update(context context.Context, id string, resources *specs.LinuxResources) error {
+	buf := getBuf()
+	defer putBuf(buf)
+
+	if err := json.NewEncoder(buf).Encode(resources); err != nil {
+		return err
+	}
+	cmd := r.command(context, "update", "--resources", "-", id)
+	cmd.Stdin = buf
+	if _, err := cmdOutput(cmd, true); err != nil {
+		return fmt.Errorf("unable to update: %w", err)
+	}
+	return nil
+}
+
 // Start will start an already created container.
 func (r *Runsc) Start(context context.Context, id string, cio runc.IO) error {
 	cmd := r.command(context, "start", id)
@@ -388,8 +404,29 @@ func (r *Runsc) Kill(context context.Context, id string, sig int, opts *KillOpts
 	return r.runOrError(r.command(context, append(args, id, strconv.Itoa(sig))...))
 }
 
-// Stats return the stats for a container like cpu, memory, and I/O.
-func (r *Runsc) Stats(context context.Context, id string) (*runc.Stats, error) {
+// Stats are the stats of a container, as reported by "runsc events". They
+// extend runc's stats with the stats of the sandbox's network interfaces.
+type Stats struct {
+	runc.Stats
+
+	NetworkInterfaces []*NetworkInterface `json:"network_interfaces,omitempty"`
+}
+
+// NetworkInterface contains stats on a network interface.
+type NetworkInterface struct {
+	Name      string `json:"name"`
+	RxBytes   uint64 `json:"rx_bytes"`
+	RxPackets uint64 `json:"rx_packets"`
+	RxErrors  uint64 `json:"rx_errors"`
+	RxDropped uint64 `json:"rx_dropped"`
+	TxBytes   uint64 `json:"tx_bytes"`
+	TxPackets uint64 `json:"tx_packets"`
+	TxErrors  uint64 `json:"tx_errors"`
+	TxDropped uint64 `json:"tx_dropped"`
+}
+
+// Stats return the stats for a container like cpu, memory, I/O and network.
+func (r *Runsc) Stats(context context.Context, id string) (*Stats, error) {
 	cmd := r.command(context, "events", "--stats", id)
 	rd, err := cmd.StdoutPipe()
 	if err != nil {
@@ -403,7 +440,10 @@ func (r *Runsc) Stats(context context.Context, id string) (*runc.Stats, error) {
 		rd.Close()
 		Monitor.Wait(cmd, ec)
 	}()
-	var e runc.Event
+	var e struct {
+		Type  string `json:"type"`
+		Stats *Stats `json:"data,omitempty"`
+	}
 	if err := json.NewDecoder(rd).Decode(&e); err != nil {
 		log.L.Debugf("Parsing events error: %v", err)
 		return nil, err
@@ -17,6 +17,7 @@ package shim
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	"io"
 	"os"
@@ -43,8 +44,10 @@ import (
 	"github.com/containerd/containerd/runtime/v2/shim"
 	taskAPI "github.com/containerd/containerd/runtime/v2/task"
 	"github.com/containerd/containerd/sys/reaper"
+	runc "github.com/containerd/go-runc"
 	"github.com/containerd/typeurl"
 	"github.com/gogo/protobuf/types"
+	specs "github.com/opencontainers/runtime-spec/specs-go"
 	"github.com/sirupsen/logrus"
 	"golang.org/x/sys/unix"
 	"gvisor.dev/gvisor/pkg/cleanup"
@@ -745,14 +748,28 @@ func (s *service) Stats(ctx context.Context, r *taskAPI.StatsRequest) (*taskAPI.
 		return nil, err
 	}
 
-	// gvisor currently (as of 2020-03-03) only returns the total memory
-	// usage and current PID value[0]. However, we copy the common fields here
-	// so that future updates will propagate correct information.  We're
-	// using the cgroups.Metrics structure so we're returning the same type
-	// as runc.
-	//
-	// [0]: https://github.com/google/gvisor/blob/277a0d5a1fbe8272d4729c01ee4c6e374d047ebc/runsc/boot/events.go#L61-L81
-	metrics := &cgroupsstats.Metrics{
+	data, err := typeurl.MarshalAny(toMetrics(stats))
+	if err != nil {
+		log.L.Debugf("Stats error, id: %s: %v", r.ID, err)
+		return nil, err
+	}
+	log.L.Debugf("Stats success, id: %s: %+v", r.ID, data)
+	return &taskAPI.StatsResponse{
+		Stats: data,
+	}, nil
+}
+
+// toMetrics converts the stats of a container to cgroups metrics.
+//
+// gVisor returns the CPU time, memory usage, I/O and current PID value
+// of the container, rather than of the whole sandbox. However, we copy
+// the common fields here so that future updates will propagate correct
+// information. We're using the cgroups.Metrics structure so we're
+// returning the same type as runc. Network stats are of the sandbox,
+// since its containers share the network namespace.
+func toMetrics(stats *runsc.Stats) *cgroupsstats.Metrics {
+	raw := stats.Memory.Raw
+	return &cgroupsstats.Metrics{
 		CPU: &cgroupsstats.CPUStat{
 			Usage: &cgroupsstats.CPUUsage{
 				Total:  stats.Cpu.Usage.Total,
@@ -767,7 +784,16 @@ func (s *service) Stats(ctx context.Context, r *taskAPI.StatsRequest) (*taskAPI.
 			},
 		},
 		Memory: &cgroupsstats.MemoryStat{
-			Cache: stats.Memory.Cache,
+			Cache:             stats.Memory.Cache,
+			RSS:               raw["rss"],
+			MappedFile:        raw["mapped_file"],
+			InactiveFile:      raw["inactive_file"],
+			ActiveFile:        raw["active_file"],
+			TotalCache:        raw["total_cache"],
+			TotalRSS:          raw["total_rss"],
+			TotalMappedFile:   raw["total_mapped_file"],
+			TotalInactiveFile: raw["total_inactive_file"],
+			TotalActiveFile:   raw["total_active_file"],
 			Usage: &cgroupsstats.MemoryEntry{
 				Limit:   stats.Memory.Usage.Limit,
 				Usage:   stats.Memory.Usage.Usage,
@@ -797,21 +823,67 @@ func (s *service) Stats(ctx context.Context, r *taskAPI.StatsRequest) (*taskAPI.
 			Current: stats.Pids.Current,
 			Limit:   stats.Pids.Limit,
 		},
+		Blkio: &cgroupsstats.BlkIOStat{
+			IoServiceBytesRecursive: blkioEntries(stats.Blkio.IoServiceBytesRecursive),
+			IoServicedRecursive:     blkioEntries(stats.Blkio.IoServicedRecursive),
+		},
+		Network: networkStats(stats.NetworkInterfaces),
 	}
-	data, err := typeurl.MarshalAny(metrics)
-	if err != nil {
-		log.L.Debugf("Stats error, id: %s: %v", r.ID, err)
-		return nil, err
+}
+
+// blkioEntries converts runc's block I/O stats to cgroups metrics.
+func blkioEntries(entries []runc.BlkioEntry) []*cgroupsstats.BlkIOEntry {
+	var out []*cgroupsstats.BlkIOEntry
+	for _, e := range entries {
+		out = append(out, &cgroupsstats.BlkIOEntry{
+			Major: e.Major,
+			Minor: e.Minor,
+			Op:    e.Op,
+			Value: e.Value,
+		})
 	}
-	log.L.Debugf("Stats success, id: %s: %+v", r.ID, data)
-	return &taskAPI.StatsResponse{
-		Stats: data,
-	}, nil
+	return out
 }
 
-// Update updates a running container.
+// networkStats converts runsc's network stats to cgroups metrics.
+func networkStats(ifaces []*runsc.NetworkInterface) []*cgroupsstats.NetworkStat {
+	var out []*cgroupsstats.NetworkStat
+	for _, i := range ifaces {
+		out = append(out, &cgroupsstats.NetworkStat{
+			Name:      i.Name,
+			RxBytes:   i.RxBytes,
+			RxPackets: i.RxPackets,
+			RxErrors:  i.RxErrors,
+			RxDropped: i.RxDropped,
+			TxBytes:   i.TxBytes,
+			TxPackets: i.TxPackets,
+			TxErrors:  i.TxErrors,
+			TxDropped: i.TxDropped,
+		})
+	}
+	return out
+}
+
+// Update updates the resources of a running container, e.g. when the
+// Kubelet resizes a pod in place.
 func (s *service) Update(ctx context.Context, r *taskAPI.UpdateTaskRequest) (*types.Empty, error) {
-	return empty, errdefs.ToGRPC(errdefs.ErrNotImplemented)
+	log.L.Debugf("Update, id: %s", r.ID)
+	if s.task == nil {
+		log.L.Debugf("Update error, id: %s: container not created", r.ID)
+		return nil, errdefs.ToGRPCf(errdefs.ErrFailedPrecondition, "container must be created")
+	}
+	if r.Resources == nil {
+		return nil, errdefs.ToGRPCf(errdefs.ErrInvalidArgument, "resources must be set")
+	}
+	var resources specs.LinuxResources
+	if err := json.Unmarshal(r.Resources.Value, &resources); err != nil {
+		return nil, errdefs.ToGRPCf(errdefs.ErrInvalidArgument, "invalid resources: %v", err)
+	}
+	if err := s.task.Runtime().Update(ctx, r.ID, &resources); err != nil {
+		log.L.Debugf("Update error, id: %s: %v", r.ID, err)
+		return nil, err
+	}
+	return empty, nil
 }
 
 // Wait waits for a process to exit.
@@ -940,6 +1012,27 @@ func getTopic(e interface{}) string {
 	return runtime.TaskUnknownTopic
 }
 
+// containerRunscConfig returns the runsc flags to use for the container with
+// the given spec. If runscConfig allows flags to be overridden by annotations
+// and the spec enables debug logging with the "dev.gvisor.flag.debug"
+// annotation, it returns a copy of runscConfig with debug enabled, so that
+// only the runsc invocations for this container log debug messages.
+// Otherwise, it returns runscConfig.
+func containerRunscConfig(spec *specs.Spec, runscConfig map[string]string) map[string]string {
+	if runscConfig["allow-flag-override"] != "true" && runscConfig["allow-container-flag-override"] != "true" {
+		return runscConfig
+	}
+	if spec.Annotations[specutils.FlagAnnotationPrefix+"debug"] != "true" {
+		return runscConfig
+	}
+	config := make(map[string]string, len(runscConfig)+1)
+	for k, v := range runscConfig {
+		config[k] = v
+	}
+	config["debug"] = "true"
+	return config
+}
+
 func newInit(path, workDir, namespace string, platform stdio.Platform, r *proc.CreateConfig, options *options, rootfs string) (*proc.Init, error) {
 	spec, err := utils.ReadSpec(r.Bundle)
 	if err != nil {
@@ -948,8 +1041,9 @@ func newInit(path, workDir, namespace string, platform stdio.Platform, r *proc.C
 	if err := utils.UpdateVolumeAnnotations(r.Bundle, spec); err != nil {
 		return nil, fmt.Errorf("update volume annotations: %w", err)
 	}
-	runsc.FormatRunscLogPath(r.ID, options.RunscConfig)
-	runtime := proc.NewRunsc(options.Root, path, namespace, options.BinaryName, options.RunscConfig)
+	config := containerRunscConfig(spec, options.RunscConfig)
+	runsc.FormatRunscLogPath(r.ID, config)
+	runtime := proc.NewRunsc(options.Root, path, namespace, options.BinaryName, config)
 	p := proc.New(r.ID, runtime, stdio.Stdio{
 		Stdin:    r.Stdin,
 		Stdout:   r.Stdout,
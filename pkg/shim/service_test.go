@@ -0,0 +1,159 @@
+// Copyright 2021 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package shim
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	cgroupsstats "github.com/containerd/cgroups/stats/v1"
+	specs "github.com/opencontainers/runtime-spec/specs-go"
+
+	"gvisor.dev/gvisor/pkg/shim/runsc"
+	"gvisor.dev/gvisor/runsc/specutils"
+)
+
+// TestToMetrics converts stats in the format of "runsc events --stats".
+func TestToMetrics(t *testing.T) {
+	const event = `{
+		"cpu": {"usage": {"total": 300, "kernel": 100, "user": 200}},
+		"memory": {
+			"cache": 4096,
+			"usage": {"usage": 16384},
+			"raw": {
+				"cache": 4096,
+				"rss": 8192,
+				"mapped_file": 1024,
+				"total_cache": 4096,
+				"total_rss": 8192,
+				"total_mapped_file": 1024
+			}
+		},
+		"pids": {"current": 3},
+		"network_interfaces": [
+			{
+				"name": "eth0",
+				"rx_bytes": 1, "rx_packets": 2, "rx_errors": 3, "rx_dropped": 4,
+				"tx_bytes": 5, "tx_packets": 6, "tx_errors": 7, "tx_dropped": 8
+			}
+		]
+	}`
+	var stats runsc.Stats
+	if err := json.Unmarshal([]byte(event), &stats); err != nil {
+		t.Fatalf("Unmarshal failed: %v", err)
+	}
+	m := toMetrics(&stats)
+
+	if got := m.CPU.Usage; got.Total != 300 || got.Kernel != 100 || got.User != 200 {
+		t.Errorf("CPU usage got %+v, want total 300, kernel 100 and user 200", got)
+	}
+	mem := m.Memory
+	if mem.Cache != 4096 || mem.RSS != 8192 || mem.MappedFile != 1024 {
+		t.Errorf("Memory got cache %d, RSS %d and mapped file %d, want 4096, 8192 and 1024", mem.Cache, mem.RSS, mem.MappedFile)
+	}
+	if mem.TotalCache != 4096 || mem.TotalRSS != 8192 || mem.TotalMappedFile != 1024 {
+		t.Errorf("Memory got total cache %d, RSS %d and mapped file %d, want 4096, 8192 and 1024", mem.TotalCache, mem.TotalRSS, mem.TotalMappedFile)
+	}
+	// With no inactive memory, the working set is the usage.
+	if mem.Usage.Usage != 16384 || mem.TotalInactiveFile != 0 {
+		t.Errorf("Memory got usage %d and total inactive file %d, want 16384 and 0", mem.Usage.Usage, mem.TotalInactiveFile)
+	}
+	if m.Pids.Current != 3 {
+		t.Errorf("Pids got current %d, want 3", m.Pids.Current)
+	}
+	want := []*cgroupsstats.NetworkStat{
+		{
+			Name:      "eth0",
+			RxBytes:   1,
+			RxPackets: 2,
+			RxErrors:  3,
+			RxDropped: 4,
+			TxBytes:   5,
+			TxPackets: 6,
+			TxErrors:  7,
+			TxDropped: 8,
+		},
+	}
+	if !reflect.DeepEqual(m.Network, want) {
+		t.Errorf("Network got %+v, want %+v", m.Network, want)
+	}
+}
+
+// TestToMetricsNoNetwork converts stats of a sandbox without network
+// interfaces.
+func TestToMetricsNoNetwork(t *testing.T) {
+	m := toMetrics(&runsc.Stats{})
+	if m.Network != nil {
+		t.Errorf("Network got %+v, want none", m.Network)
+	}
+	if m.Memory.RSS != 0 || m.Memory.Cache != 0 {
+		t.Errorf("Memory got RSS %d and cache %d, want 0", m.Memory.RSS, m.Memory.Cache)
+	}
+}
+
+func TestContainerRunscConfig(t *testing.T) {
+	debugSpec := &specs.Spec{
+		Annotations: map[string]string{
+			specutils.FlagAnnotationPrefix + "debug": "true",
+		},
+	}
+	for _, tc := range []struct {
+		name   string
+		spec   *specs.Spec
+		config map[string]string
+		want   map[string]string
+	}{
+		{
+			name:   "container override",
+			spec:   debugSpec,
+			config: map[string]string{"allow-container-flag-override": "true", "debug-log": "/tmp/%ID%/"},
+			want:   map[string]string{"allow-container-flag-override": "true", "debug-log": "/tmp/%ID%/", "debug": "true"},
+		},
+		{
+			name:   "flag override",
+			spec:   debugSpec,
+			config: map[string]string{"allow-flag-override": "true"},
+			want:   map[string]string{"allow-flag-override": "true", "debug": "true"},
+		},
+		{
+			name:   "override not allowed",
+			spec:   debugSpec,
+			config: map[string]string{"debug-log": "/tmp/%ID%/"},
+			want:   map[string]string{"debug-log": "/tmp/%ID%/"},
+		},
+		{
+			name:   "no annotation",
+			spec:   &specs.Spec{},
+			config: map[string]string{"allow-container-flag-override": "true"},
+			want:   map[string]string{"allow-container-flag-override": "true"},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			orig := make(map[string]string, len(tc.config))
+			for k, v := range tc.config {
+				orig[k] = v
+			}
+			if got := containerRunscConfig(tc.spec, tc.config); !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("containerRunscConfig() got %v, want %v", got, tc.want)
+			}
+			// The configuration is shared by all containers of the shim, so
+			// it must not be changed.
+			if !reflect.DeepEqual(tc.config, orig) {
+				t.Errorf("containerRunscConfig() changed the shim's configuration to %v, want %v", tc.config, orig)
+			}
+		})
+	}
+}
@@ -0,0 +1,134 @@
+// Copyright 2021 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package squashfs
+
+import (
+	"encoding/binary"
+	"strings"
+	"syscall"
+)
+
+// Sizes of directory listing structures.
+const (
+	// dirHeaderSize is the size of struct squashfs_dir_header.
+	dirHeaderSize = 12
+
+	// direntSize is the size of struct squashfs_dir_entry, not including the
+	// name.
+	direntSize = 8
+
+	// maxDirHeaderEntries is the maximum number of entries following a
+	// directory header.
+	maxDirHeaderEntries = 256
+
+	// dirSizeOffset is added to the size of directory listings in directory
+	// inodes, to account for "." and "..", which aren't stored.
+	dirSizeOffset = 3
+)
+
+// File types of directory entries. These are the basic inode types.
+const (
+	FileTypeDirectory = inodeTypeDir
+	FileTypeRegular   = inodeTypeReg
+	FileTypeSymlink   = inodeTypeSymlink
+	FileTypeBlockDev  = inodeTypeBlkDev
+	FileTypeCharDev   = inodeTypeChrDev
+	FileTypeFIFO      = inodeTypeFIFO
+	FileTypeSocket    = inodeTypeSocket
+)
+
+// Dirent is a directory entry.
+type Dirent struct {
+	Name string
+
+	// Ino is the inode number of the entry's inode.
+	Ino uint32
+
+	// Ref is the reference of the entry's inode, as passed to Image.Inode.
+	Ref uint64
+
+	FileType uint16
+}
+
+// Dirents returns the entries of a directory in the order in which they are
+// stored, which is sorted by name. "." and ".." are not stored in the image
+// and aren't returned.
+func (in *Inode) Dirents() ([]Dirent, error) {
+	if !in.IsDir() {
+		return nil, syscall.ENOTDIR
+	}
+	if in.Size < dirSizeOffset {
+		return nil, errCorrupted
+	}
+	remaining := in.Size - dirSizeOffset
+	if remaining == 0 {
+		return nil, nil
+	}
+	r, err := in.image.newMetadataReader(in.image.sb.DirectoryTableStart, uint64(in.dirBlock), in.dirOffset)
+	if err != nil {
+		return nil, err
+	}
+	le := binary.LittleEndian
+	var dirents []Dirent
+	for remaining > 0 {
+		if remaining < dirHeaderSize {
+			return nil, errCorrupted
+		}
+		var hdr [dirHeaderSize]byte
+		if err := r.read(hdr[:]); err != nil {
+			return nil, err
+		}
+		remaining -= dirHeaderSize
+		count := le.Uint32(hdr[0:]) + 1
+		start := le.Uint32(hdr[4:])
+		baseIno := le.Uint32(hdr[8:])
+		if count > maxDirHeaderEntries {
+			return nil, errCorrupted
+		}
+		for j := uint32(0); j < count; j++ {
+			if remaining < direntSize {
+				return nil, errCorrupted
+			}
+			var d [direntSize]byte
+			if err := r.read(d[:]); err != nil {
+				return nil, err
+			}
+			remaining -= direntSize
+			nameSize := uint64(le.Uint16(d[6:])) + 1
+			if nameSize > MaxFileName || remaining < nameSize {
+				return nil, errCorrupted
+			}
+			name := make([]byte, nameSize)
+			if err := r.read(name); err != nil {
+				return nil, err
+			}
+			remaining -= nameSize
+			dirent := Dirent{
+				Name:     string(name),
+				Ino:      uint32(int32(baseIno) + int32(int16(le.Uint16(d[2:])))),
+				Ref:      uint64(start)<<16 | uint64(le.Uint16(d[0:])),
+				FileType: le.Uint16(d[4:]),
+			}
+			if dirent.Name == "." || dirent.Name == ".." || strings.ContainsAny(dirent.Name, "/\x00") {
+				return nil, errCorrupted
+			}
+			if dirent.FileType < FileTypeDirectory || dirent.FileType > FileTypeSocket {
+				return nil, errCorrupted
+			}
+			dirents = append(dirents, dirent)
+		}
+	}
+	return dirents, nil
+}
@@ -0,0 +1,357 @@
+// Copyright 2021 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package squashfs
+
+import (
+	"encoding/binary"
+	"io"
+	"syscall"
+)
+
+// Inode types. Extended types have additional fields, such as a link count
+// or an extended attribute index.
+const (
+	inodeTypeDir            = 1
+	inodeTypeReg            = 2
+	inodeTypeSymlink        = 3
+	inodeTypeBlkDev         = 4
+	inodeTypeChrDev         = 5
+	inodeTypeFIFO           = 6
+	inodeTypeSocket         = 7
+	inodeTypeLDir           = 8
+	inodeTypeLReg           = 9
+	inodeTypeLSymlink       = 10
+	inodeTypeLBlkDev        = 11
+	inodeTypeLChrDev        = 12
+	inodeTypeLFIFO          = 13
+	inodeTypeLSocket        = 14
+	inodeTypeExtendedOffset = inodeTypeLDir - inodeTypeDir
+)
+
+// Sizes of the parts of on-disk inodes that precede variable-length data.
+const (
+	inodeHeaderSize  = 16
+	dirInodeSize     = 16
+	lDirInodeSize    = 24
+	regInodeSize     = 16
+	lRegInodeSize    = 40
+	symlinkInodeSize = 8
+	devInodeSize     = 8
+	ipcInodeSize     = 4
+)
+
+// noFragment is the fragment index of files whose tail is not stored in a
+// fragment.
+const noFragment = 0xffffffff
+
+// File mode types, as in stat(2).
+const (
+	modeDir     = syscall.S_IFDIR
+	modeReg     = syscall.S_IFREG
+	modeSymlink = syscall.S_IFLNK
+	modeBlkDev  = syscall.S_IFBLK
+	modeChrDev  = syscall.S_IFCHR
+	modeFIFO    = syscall.S_IFIFO
+	modeSocket  = syscall.S_IFSOCK
+)
+
+// modeTypes maps basic inode types to file mode types.
+var modeTypes = [...]uint16{
+	inodeTypeDir:     modeDir,
+	inodeTypeReg:     modeReg,
+	inodeTypeSymlink: modeSymlink,
+	inodeTypeBlkDev:  modeBlkDev,
+	inodeTypeChrDev:  modeChrDev,
+	inodeTypeFIFO:    modeFIFO,
+	inodeTypeSocket:  modeSocket,
+}
+
+// Inode is an inode of a SquashFS image.
+type Inode struct {
+	image *Image
+
+	// Ref is the inode's reference: the offset of its metadata block from
+	// the start of the inode table, shifted left by 16, ORed with its offset
+	// in that block.
+	Ref uint64
+
+	// Ino is the inode number.
+	Ino uint32
+
+	// Mode is the file mode, including the file type.
+	Mode  uint16
+	Nlink uint32
+	Size  uint64
+	UID   uint32
+	GID   uint32
+	Mtime uint32
+
+	// Rdev is the device number of device inodes, encoded as by Linux's
+	// new_encode_dev().
+	Rdev uint32
+
+	// Parent is the inode number of the parent of directory inodes.
+	Parent uint32
+
+	// dirBlock and dirOffset locate the listing of directory inodes in the
+	// directory table.
+	dirBlock  uint32
+	dirOffset uint16
+
+	// blocks are the offsets in the image of the data blocks of regular
+	// files, and blockSizes are their sizes as stored in the inode.
+	blocks     []uint64
+	blockSizes []uint32
+
+	// fragIndex and fragOffset locate the tail of regular files in the
+	// fragment table.
+	fragIndex  uint32
+	fragOffset uint32
+
+	// target is the target of symbolic links.
+	target string
+}
+
+// Inode reads the inode with the given reference.
+func (i *Image) Inode(ref uint64) (*Inode, error) {
+	r, err := i.newMetadataReader(i.sb.InodeTableStart, ref>>16, uint16(ref))
+	if err != nil {
+		return nil, err
+	}
+	var hdr [inodeHeaderSize]byte
+	if err := r.read(hdr[:]); err != nil {
+		return nil, err
+	}
+	le := binary.LittleEndian
+	typ := le.Uint16(hdr[0:])
+	basicType := typ
+	if typ >= inodeTypeLDir {
+		basicType -= inodeTypeExtendedOffset
+	}
+	if basicType < inodeTypeDir || basicType > inodeTypeSocket {
+		return nil, errCorrupted
+	}
+	in := &Inode{
+		image: i,
+		Ref:   ref,
+		Mode:  modeTypes[basicType] | le.Uint16(hdr[2:])&07777,
+		Mtime: le.Uint32(hdr[8:]),
+		Ino:   le.Uint32(hdr[12:]),
+		Nlink: 1,
+	}
+	if in.UID, err = i.id(le.Uint16(hdr[4:])); err != nil {
+		return nil, err
+	}
+	if in.GID, err = i.id(le.Uint16(hdr[6:])); err != nil {
+		return nil, err
+	}
+
+	switch typ {
+	case inodeTypeDir:
+		var b [dirInodeSize]byte
+		if err := r.read(b[:]); err != nil {
+			return nil, err
+		}
+		in.dirBlock = le.Uint32(b[0:])
+		in.Nlink = le.Uint32(b[4:])
+		in.Size = uint64(le.Uint16(b[8:]))
+		in.dirOffset = le.Uint16(b[10:])
+		in.Parent = le.Uint32(b[12:])
+	case inodeTypeLDir:
+		var b [lDirInodeSize]byte
+		if err := r.read(b[:]); err != nil {
+			return nil, err
+		}
+		in.Nlink = le.Uint32(b[0:])
+		in.Size = uint64(le.Uint32(b[4:]))
+		in.dirBlock = le.Uint32(b[8:])
+		in.Parent = le.Uint32(b[12:])
+		in.dirOffset = le.Uint16(b[18:])
+	case inodeTypeReg:
+		var b [regInodeSize]byte
+		if err := r.read(b[:]); err != nil {
+			return nil, err
+		}
+		start := uint64(le.Uint32(b[0:]))
+		in.fragIndex = le.Uint32(b[4:])
+		in.fragOffset = le.Uint32(b[8:])
+		in.Size = uint64(le.Uint32(b[12:]))
+		if err := in.readBlockList(r, start); err != nil {
+			return nil, err
+		}
+	case inodeTypeLReg:
+		var b [lRegInodeSize]byte
+		if err := r.read(b[:]); err != nil {
+			return nil, err
+		}
+		start := le.Uint64(b[0:])
+		in.Size = le.Uint64(b[8:])
+		in.Nlink = le.Uint32(b[24:])
+		in.fragIndex = le.Uint32(b[28:])
+		in.fragOffset = le.Uint32(b[32:])
+		if err := in.readBlockList(r, start); err != nil {
+			return nil, err
+		}
+	case inodeTypeSymlink, inodeTypeLSymlink:
+		var b [symlinkInodeSize]byte
+		if err := r.read(b[:]); err != nil {
+			return nil, err
+		}
+		in.Nlink = le.Uint32(b[0:])
+		in.Size = uint64(le.Uint32(b[4:]))
+		if in.Size > syscall.PathMax {
+			return nil, errCorrupted
+		}
+		target := make([]byte, in.Size)
+		if err := r.read(target); err != nil {
+			return nil, err
+		}
+		in.target = string(target)
+	case inodeTypeBlkDev, inodeTypeChrDev, inodeTypeLBlkDev, inodeTypeLChrDev:
+		var b [devInodeSize]byte
+		if err := r.read(b[:]); err != nil {
+			return nil, err
+		}
+		in.Nlink = le.Uint32(b[0:])
+		in.Rdev = le.Uint32(b[4:])
+	case inodeTypeFIFO, inodeTypeSocket, inodeTypeLFIFO, inodeTypeLSocket:
+		var b [ipcInodeSize]byte
+		if err := r.read(b[:]); err != nil {
+			return nil, err
+		}
+		in.Nlink = le.Uint32(b[0:])
+	}
+	return in, nil
+}
+
+// readBlockList reads the sizes of the data blocks of a regular file, which
+// follow the inode, and computes the blocks' offsets from start, the offset
+// of the first block.
+func (in *Inode) readBlockList(r *metadataReader, start uint64) error {
+	blockSize := uint64(in.image.sb.BlockSize)
+	count := in.Size / blockSize
+	if in.fragIndex == noFragment && in.Size%blockSize != 0 {
+		count++
+	}
+	// Each block's size takes 4 bytes in the image, so the list can't be
+	// larger than the image.
+	if count*4 > in.image.sb.BytesUsed {
+		return errCorrupted
+	}
+	sizes := make([]byte, count*4)
+	if err := r.read(sizes); err != nil {
+		return err
+	}
+	in.blocks = make([]uint64, count)
+	in.blockSizes = make([]uint32, count)
+	off := start
+	for j := range in.blocks {
+		size := binary.LittleEndian.Uint32(sizes[j*4:])
+		in.blocks[j] = off
+		in.blockSizes[j] = size
+		off += uint64(size & dataSizeMask)
+	}
+	return nil
+}
+
+// IsDir returns true if the inode is a directory.
+func (in *Inode) IsDir() bool {
+	return in.Mode&syscall.S_IFMT == modeDir
+}
+
+// IsRegular returns true if the inode is a regular file.
+func (in *Inode) IsRegular() bool {
+	return in.Mode&syscall.S_IFMT == modeReg
+}
+
+// IsSymlink returns true if the inode is a symbolic link.
+func (in *Inode) IsSymlink() bool {
+	return in.Mode&syscall.S_IFMT == modeSymlink
+}
+
+// block returns the decompressed data of the idx-th block of a regular file.
+// The returned data must not be modified. A nil slice is returned for holes.
+func (in *Inode) block(idx uint64) ([]byte, error) {
+	if idx < uint64(len(in.blocks)) {
+		size := in.blockSizes[idx]
+		if size&dataSizeMask == 0 {
+			return nil, nil
+		}
+		return in.image.readDataBlock(in.blocks[idx], size)
+	}
+	// The tail is stored in a fragment.
+	if in.fragIndex == noFragment || uint64(in.fragIndex) >= uint64(len(in.image.fragments)) {
+		return nil, errCorrupted
+	}
+	frag := in.image.fragments[in.fragIndex]
+	data, err := in.image.readDataBlock(frag.start, frag.size)
+	if err != nil {
+		return nil, err
+	}
+	if uint64(in.fragOffset) > uint64(len(data)) {
+		return nil, errCorrupted
+	}
+	return data[in.fragOffset:], nil
+}
+
+// ReadAt implements io.ReaderAt.ReadAt for the data of the inode.
+func (in *Inode) ReadAt(p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, syscall.EINVAL
+	}
+	if !in.IsRegular() {
+		return 0, syscall.EINVAL
+	}
+	blockSize := uint64(in.image.sb.BlockSize)
+	var done int
+	for done < len(p) {
+		pos := uint64(off) + uint64(done)
+		if pos >= in.Size {
+			return done, io.EOF
+		}
+		data, err := in.block(pos / blockSize)
+		if err != nil {
+			return done, err
+		}
+		blockOff := pos % blockSize
+		n := blockSize - blockOff
+		if rem := in.Size - pos; n > rem {
+			n = rem
+		}
+		if rem := uint64(len(p) - done); n > rem {
+			n = rem
+		}
+		dst := p[done : done+int(n)]
+		if data == nil {
+			for j := range dst {
+				dst[j] = 0
+			}
+		} else if blockOff+n > uint64(len(data)) {
+			return done, errCorrupted
+		} else {
+			copy(dst, data[blockOff:])
+		}
+		done += int(n)
+	}
+	return done, nil
+}
+
+// Readlink returns the target of a symbolic link.
+func (in *Inode) Readlink() (string, error) {
+	if !in.IsSymlink() {
+		return "", syscall.EINVAL
+	}
+	return in.target, nil
+}
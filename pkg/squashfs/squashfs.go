@@ -0,0 +1,450 @@
+// Copyright 2021 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package squashfs reads SquashFS 4.0 images.
+//
+// Metadata and data compressed with gzip (zlib streams) are supported, as are
+// uncompressed blocks in images built with any compressor. Images whose
+// compressor is something other than gzip are rejected, since their
+// compressed blocks can't be read. Extended attributes are ignored.
+//
+// See Linux's fs/squashfs/squashfs_fs.h for the on-disk format.
+package squashfs
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"io"
+	"syscall"
+
+	"gvisor.dev/gvisor/pkg/sync"
+)
+
+// Superblock location and magic.
+const (
+	// SuperBlockMagic is the magic number of SquashFS images.
+	SuperBlockMagic = 0x73717368
+
+	// superBlockSize is the size of the on-disk superblock, which is at the
+	// start of the image.
+	superBlockSize = 96
+)
+
+// Block size limits, as log2 of the block size.
+const (
+	minBlockLog = 12
+	maxBlockLog = 20
+)
+
+// MaxFileName is the maximum length of a file name.
+const MaxFileName = 256
+
+// Compressors.
+const (
+	CompressionGzip = 1
+	CompressionLZMA = 2
+	CompressionLZO  = 3
+	CompressionXZ   = 4
+	CompressionLZ4  = 5
+	CompressionZstd = 6
+)
+
+// Metadata blocks.
+const (
+	// metadataSize is the maximum uncompressed size of a metadata block.
+	metadataSize = 8192
+
+	// metadataUncompressed is set in the header of uncompressed metadata
+	// blocks.
+	metadataUncompressed = 1 << 15
+)
+
+// Data and fragment block sizes.
+const (
+	// dataUncompressed is set in the size of uncompressed data and fragment
+	// blocks.
+	dataUncompressed = 1 << 24
+
+	// dataSizeMask masks the on-disk size of data and fragment blocks.
+	dataSizeMask = dataUncompressed - 1
+)
+
+// Sizes of table entries.
+const (
+	idEntrySize       = 4
+	fragmentEntrySize = 16
+)
+
+// Cache sizes, in blocks.
+const (
+	metadataCacheBlocks = 64
+	dataCacheBlocks     = 8
+)
+
+// errCorrupted is returned for malformed images, as EFSCORRUPTED is in Linux.
+var errCorrupted = syscall.EUCLEAN
+
+// SuperBlock is the superblock of a SquashFS image.
+type SuperBlock struct {
+	Magic               uint32
+	Inodes              uint32
+	MkfsTime            uint32
+	BlockSize           uint32
+	Fragments           uint32
+	Compression         uint16
+	BlockLog            uint16
+	Flags               uint16
+	NoIDs               uint16
+	Major               uint16
+	Minor               uint16
+	RootInode           uint64
+	BytesUsed           uint64
+	IDTableStart        uint64
+	XattrIDTableStart   uint64
+	InodeTableStart     uint64
+	DirectoryTableStart uint64
+	FragmentTableStart  uint64
+	LookupTableStart    uint64
+}
+
+// decode decodes the superblock from b, which must be at least
+// superBlockSize bytes long.
+func (sb *SuperBlock) decode(b []byte) {
+	le := binary.LittleEndian
+	sb.Magic = le.Uint32(b[0:])
+	sb.Inodes = le.Uint32(b[4:])
+	sb.MkfsTime = le.Uint32(b[8:])
+	sb.BlockSize = le.Uint32(b[12:])
+	sb.Fragments = le.Uint32(b[16:])
+	sb.Compression = le.Uint16(b[20:])
+	sb.BlockLog = le.Uint16(b[22:])
+	sb.Flags = le.Uint16(b[24:])
+	sb.NoIDs = le.Uint16(b[26:])
+	sb.Major = le.Uint16(b[28:])
+	sb.Minor = le.Uint16(b[30:])
+	sb.RootInode = le.Uint64(b[32:])
+	sb.BytesUsed = le.Uint64(b[40:])
+	sb.IDTableStart = le.Uint64(b[48:])
+	sb.XattrIDTableStart = le.Uint64(b[56:])
+	sb.InodeTableStart = le.Uint64(b[64:])
+	sb.DirectoryTableStart = le.Uint64(b[72:])
+	sb.FragmentTableStart = le.Uint64(b[80:])
+	sb.LookupTableStart = le.Uint64(b[88:])
+}
+
+// fragment is an entry of the fragment table.
+type fragment struct {
+	start uint64
+	size  uint32
+}
+
+// Image is a SquashFS image. Image is safe for concurrent use.
+type Image struct {
+	// src is the image. src is immutable.
+	src io.ReaderAt
+
+	// sb is the superblock. sb is immutable.
+	sb SuperBlock
+
+	// ids is the ID table, which maps the UID and GID indices of inodes to
+	// IDs. ids is immutable.
+	ids []uint32
+
+	// fragments is the fragment table. fragments is immutable.
+	fragments []fragment
+
+	// metadataCache caches decompressed metadata blocks.
+	metadataCache blockCache
+
+	// dataCache caches decompressed data and fragment blocks.
+	dataCache blockCache
+}
+
+// OpenImage reads the superblock, ID table and fragment table of the image in
+// src and returns the image.
+func OpenImage(src io.ReaderAt) (*Image, error) {
+	i := &Image{
+		src:           src,
+		metadataCache: blockCache{capacity: metadataCacheBlocks},
+		dataCache:     blockCache{capacity: dataCacheBlocks},
+	}
+	var buf [superBlockSize]byte
+	if err := i.readFull(buf[:], 0); err != nil {
+		return nil, err
+	}
+	i.sb.decode(buf[:])
+	if i.sb.Magic != SuperBlockMagic {
+		return nil, syscall.EINVAL
+	}
+	if i.sb.Major != 4 || i.sb.Minor != 0 {
+		return nil, syscall.EINVAL
+	}
+	if i.sb.BlockLog < minBlockLog || i.sb.BlockLog > maxBlockLog || i.sb.BlockSize != 1<<i.sb.BlockLog {
+		return nil, syscall.EINVAL
+	}
+	if i.sb.Compression != CompressionGzip {
+		return nil, syscall.EOPNOTSUPP
+	}
+
+	ids, err := i.readTable(i.sb.IDTableStart, int(i.sb.NoIDs)*idEntrySize)
+	if err != nil {
+		return nil, err
+	}
+	i.ids = make([]uint32, i.sb.NoIDs)
+	for j := range i.ids {
+		i.ids[j] = binary.LittleEndian.Uint32(ids[j*idEntrySize:])
+	}
+
+	if uint64(i.sb.Fragments)*fragmentEntrySize > i.sb.BytesUsed {
+		return nil, errCorrupted
+	}
+	fragments, err := i.readTable(i.sb.FragmentTableStart, int(i.sb.Fragments)*fragmentEntrySize)
+	if err != nil {
+		return nil, err
+	}
+	i.fragments = make([]fragment, i.sb.Fragments)
+	for j := range i.fragments {
+		b := fragments[j*fragmentEntrySize:]
+		i.fragments[j] = fragment{
+			start: binary.LittleEndian.Uint64(b[0:]),
+			size:  binary.LittleEndian.Uint32(b[8:]),
+		}
+	}
+	return i, nil
+}
+
+// SuperBlock returns the superblock of the image.
+func (i *Image) SuperBlock() *SuperBlock {
+	return &i.sb
+}
+
+// BlockSize returns the block size of the image.
+func (i *Image) BlockSize() uint32 {
+	return i.sb.BlockSize
+}
+
+// RootInodeRef returns the reference of the root directory's inode.
+func (i *Image) RootInodeRef() uint64 {
+	return i.sb.RootInode
+}
+
+// id returns the ID with the given index in the ID table.
+func (i *Image) id(idx uint16) (uint32, error) {
+	if int(idx) >= len(i.ids) {
+		return 0, errCorrupted
+	}
+	return i.ids[idx], nil
+}
+
+// readFull reads len(b) bytes at offset off of the image. Short reads are
+// reported as corruption, since all metadata must lie within the image.
+func (i *Image) readFull(b []byte, off uint64) error {
+	n, err := i.src.ReadAt(b, int64(off))
+	if n == len(b) {
+		return nil
+	}
+	if err == nil || err == io.EOF {
+		return errCorrupted
+	}
+	return err
+}
+
+// decompress decompresses src, which must decompress to at most max bytes.
+func (i *Image) decompress(src []byte, max int) ([]byte, error) {
+	r, err := zlib.NewReader(bytes.NewReader(src))
+	if err != nil {
+		return nil, errCorrupted
+	}
+	defer r.Close()
+	var buf bytes.Buffer
+	if _, err := io.Copy(&buf, io.LimitReader(r, int64(max)+1)); err != nil {
+		return nil, errCorrupted
+	}
+	if buf.Len() > max {
+		return nil, errCorrupted
+	}
+	return buf.Bytes(), nil
+}
+
+// readMetadataBlock reads the metadata block at offset off of the image. It
+// returns the block's data and the offset of the next metadata block. The
+// returned data must not be modified.
+func (i *Image) readMetadataBlock(off uint64) ([]byte, uint64, error) {
+	var hdr [2]byte
+	if err := i.readFull(hdr[:], off); err != nil {
+		return nil, 0, err
+	}
+	size := binary.LittleEndian.Uint16(hdr[:])
+	diskSize := uint64(size &^ metadataUncompressed)
+	if diskSize == 0 || diskSize > metadataSize {
+		return nil, 0, errCorrupted
+	}
+	next := off + uint64(len(hdr)) + diskSize
+	if data, ok := i.metadataCache.get(off); ok {
+		return data, next, nil
+	}
+	data := make([]byte, diskSize)
+	if err := i.readFull(data, off+uint64(len(hdr))); err != nil {
+		return nil, 0, err
+	}
+	if size&metadataUncompressed == 0 {
+		var err error
+		if data, err = i.decompress(data, metadataSize); err != nil {
+			return nil, 0, err
+		}
+	}
+	i.metadataCache.put(off, data)
+	return data, next, nil
+}
+
+// readDataBlock reads the data or fragment block at offset off of the image.
+// size is the block's size as stored in the inode or fragment table. The
+// returned data must not be modified.
+func (i *Image) readDataBlock(off uint64, size uint32) ([]byte, error) {
+	diskSize := size & dataSizeMask
+	if diskSize > i.sb.BlockSize {
+		return nil, errCorrupted
+	}
+	if data, ok := i.dataCache.get(off); ok {
+		return data, nil
+	}
+	data := make([]byte, diskSize)
+	if err := i.readFull(data, off); err != nil {
+		return nil, err
+	}
+	if size&dataUncompressed == 0 {
+		var err error
+		if data, err = i.decompress(data, int(i.sb.BlockSize)); err != nil {
+			return nil, err
+		}
+	}
+	i.dataCache.put(off, data)
+	return data, nil
+}
+
+// readTable reads size bytes of the table whose index of metadata blocks is
+// at offset off of the image.
+func (i *Image) readTable(off uint64, size int) ([]byte, error) {
+	if size == 0 {
+		return nil, nil
+	}
+	blocks := (size + metadataSize - 1) / metadataSize
+	index := make([]byte, blocks*8)
+	if err := i.readFull(index, off); err != nil {
+		return nil, err
+	}
+	table := make([]byte, 0, size)
+	for j := 0; j < blocks; j++ {
+		data, _, err := i.readMetadataBlock(binary.LittleEndian.Uint64(index[j*8:]))
+		if err != nil {
+			return nil, err
+		}
+		table = append(table, data...)
+	}
+	if len(table) < size {
+		return nil, errCorrupted
+	}
+	return table[:size], nil
+}
+
+// metadataReader reads a stream of metadata, which may span several metadata
+// blocks.
+type metadataReader struct {
+	image *Image
+
+	// next is the offset in the image of the next metadata block.
+	next uint64
+
+	// buf is the unread data of the current metadata block.
+	buf []byte
+}
+
+// newMetadataReader returns a reader of the metadata at the given offset in
+// the metadata block at block bytes from the start of the table at
+// tableStart.
+func (i *Image) newMetadataReader(tableStart, block uint64, offset uint16) (*metadataReader, error) {
+	data, next, err := i.readMetadataBlock(tableStart + block)
+	if err != nil {
+		return nil, err
+	}
+	if int(offset) > len(data) {
+		return nil, errCorrupted
+	}
+	return &metadataReader{
+		image: i,
+		next:  next,
+		buf:   data[offset:],
+	}, nil
+}
+
+// read fills b with the next len(b) bytes of metadata.
+func (r *metadataReader) read(b []byte) error {
+	for len(b) > 0 {
+		if len(r.buf) == 0 {
+			data, next, err := r.image.readMetadataBlock(r.next)
+			if err != nil {
+				return err
+			}
+			r.buf, r.next = data, next
+		}
+		n := copy(b, r.buf)
+		b = b[n:]
+		r.buf = r.buf[n:]
+	}
+	return nil
+}
+
+// blockCache is a small cache of decompressed blocks, keyed by their offset
+// in the image. The oldest block is evicted when the cache is full.
+type blockCache struct {
+	// capacity is the maximum number of blocks in the cache. capacity is
+	// immutable.
+	capacity int
+
+	// mu protects the fields below.
+	mu sync.Mutex
+
+	// blocks maps offsets to blocks.
+	blocks map[uint64][]byte
+
+	// order holds the offsets of the cached blocks, oldest first.
+	order []uint64
+}
+
+// get returns the block at offset off, if it is cached.
+func (c *blockCache) get(off uint64) ([]byte, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	data, ok := c.blocks[off]
+	return data, ok
+}
+
+// put adds the block at offset off to the cache.
+func (c *blockCache) put(off uint64, data []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.blocks[off]; ok {
+		return
+	}
+	if c.blocks == nil {
+		c.blocks = make(map[uint64][]byte)
+	}
+	if len(c.order) >= c.capacity {
+		delete(c.blocks, c.order[0])
+		c.order = c.order[1:]
+	}
+	c.blocks[off] = data
+	c.order = append(c.order, off)
+}
@@ -0,0 +1,573 @@
+// Copyright 2021 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package squashfs
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"io"
+	"reflect"
+	"sort"
+	"syscall"
+	"testing"
+)
+
+// Test images use 4096-byte blocks.
+const (
+	testBlockLog  = 12
+	testBlockSize = 1 << testBlockLog
+	testMtime     = 1000
+)
+
+// imageBuilder builds SquashFS images for tests. Inodes and directory
+// listings are added bottom-up, since directories refer to their children.
+// The inode table must fit in a single metadata block.
+type imageBuilder struct {
+	// data holds the data blocks, which follow the superblock.
+	data bytes.Buffer
+
+	// inodes, dirs and frag are the uncompressed inode table, directory
+	// table and fragment block.
+	inodes bytes.Buffer
+	dirs   bytes.Buffer
+	frag   bytes.Buffer
+
+	ids     []uint32
+	nextIno uint32
+}
+
+func put16(buf *bytes.Buffer, v uint16) {
+	binary.Write(buf, binary.LittleEndian, v)
+}
+
+func put32(buf *bytes.Buffer, v uint32) {
+	binary.Write(buf, binary.LittleEndian, v)
+}
+
+func put64(buf *bytes.Buffer, v uint64) {
+	binary.Write(buf, binary.LittleEndian, v)
+}
+
+func compress(b []byte) []byte {
+	var buf bytes.Buffer
+	w := zlib.NewWriter(&buf)
+	w.Write(b)
+	w.Close()
+	return buf.Bytes()
+}
+
+func (ib *imageBuilder) pos() uint64 {
+	return superBlockSize + uint64(ib.data.Len())
+}
+
+func (ib *imageBuilder) idIndex(id uint32) uint16 {
+	for j, v := range ib.ids {
+		if v == id {
+			return uint16(j)
+		}
+	}
+	ib.ids = append(ib.ids, id)
+	return uint16(len(ib.ids) - 1)
+}
+
+// header writes an inode header and returns the inode's dirent, with name
+// left empty.
+func (ib *imageBuilder) header(typ, perm uint16, uid, gid uint32) Dirent {
+	ib.nextIno++
+	d := Dirent{
+		Ino:      ib.nextIno,
+		Ref:      uint64(ib.inodes.Len()),
+		FileType: typ,
+	}
+	if typ >= inodeTypeLDir {
+		d.FileType -= inodeTypeExtendedOffset
+	}
+	put16(&ib.inodes, typ)
+	put16(&ib.inodes, perm)
+	put16(&ib.inodes, ib.idIndex(uid))
+	put16(&ib.inodes, ib.idIndex(gid))
+	put32(&ib.inodes, testMtime)
+	put32(&ib.inodes, d.Ino)
+	return d
+}
+
+// file adds a regular file. Full blocks of zeroes are stored as holes, and
+// full blocks are compressed if compressBlocks is set. The tail is stored in
+// the fragment block unless noFrag is set.
+func (ib *imageBuilder) file(content []byte, extended, compressBlocks, noFrag bool) Dirent {
+	start := ib.pos()
+	var sizes []uint32
+	rest := content
+	for len(rest) >= testBlockSize || (noFrag && len(rest) > 0) {
+		n := len(rest)
+		if n > testBlockSize {
+			n = testBlockSize
+		}
+		block := rest[:n]
+		rest = rest[n:]
+		switch {
+		case bytes.Equal(block, make([]byte, n)):
+			sizes = append(sizes, 0)
+		case compressBlocks:
+			c := compress(block)
+			ib.data.Write(c)
+			sizes = append(sizes, uint32(len(c)))
+		default:
+			ib.data.Write(block)
+			sizes = append(sizes, uint32(n)|dataUncompressed)
+		}
+	}
+	fragIndex, fragOffset := uint32(noFragment), uint32(0)
+	if len(rest) > 0 {
+		fragIndex, fragOffset = 0, uint32(ib.frag.Len())
+		ib.frag.Write(rest)
+	}
+
+	var d Dirent
+	if extended {
+		d = ib.header(inodeTypeLReg, 0644, 0, 0)
+		put64(&ib.inodes, start)
+		put64(&ib.inodes, uint64(len(content)))
+		put64(&ib.inodes, 0)
+		put32(&ib.inodes, 1)
+		put32(&ib.inodes, fragIndex)
+		put32(&ib.inodes, fragOffset)
+		put32(&ib.inodes, 0xffffffff)
+	} else {
+		d = ib.header(inodeTypeReg, 0644, 0, 0)
+		put32(&ib.inodes, uint32(start))
+		put32(&ib.inodes, fragIndex)
+		put32(&ib.inodes, fragOffset)
+		put32(&ib.inodes, uint32(len(content)))
+	}
+	for _, size := range sizes {
+		put32(&ib.inodes, size)
+	}
+	return d
+}
+
+func (ib *imageBuilder) symlink(target string) Dirent {
+	d := ib.header(inodeTypeSymlink, 0777, 0, 0)
+	put32(&ib.inodes, 1)
+	put32(&ib.inodes, uint32(len(target)))
+	ib.inodes.WriteString(target)
+	return d
+}
+
+func (ib *imageBuilder) chrDev(rdev uint32) Dirent {
+	d := ib.header(inodeTypeChrDev, 0666, 0, 0)
+	put32(&ib.inodes, 1)
+	put32(&ib.inodes, rdev)
+	return d
+}
+
+// dir adds a directory holding children, with all entries under a single
+// directory header.
+func (ib *imageBuilder) dir(children []Dirent, extended bool, uid uint32) Dirent {
+	sort.Slice(children, func(i, j int) bool { return children[i].Name < children[j].Name })
+	dirOffset := ib.dirs.Len()
+	if len(children) > 0 {
+		base := children[0].Ino
+		put32(&ib.dirs, uint32(len(children)-1))
+		put32(&ib.dirs, 0)
+		put32(&ib.dirs, base)
+		for _, c := range children {
+			put16(&ib.dirs, uint16(c.Ref))
+			put16(&ib.dirs, uint16(int16(c.Ino-base)))
+			put16(&ib.dirs, c.FileType)
+			put16(&ib.dirs, uint16(len(c.Name)-1))
+			ib.dirs.WriteString(c.Name)
+		}
+	}
+	size := uint32(ib.dirs.Len()-dirOffset) + dirSizeOffset
+	nlink := uint32(2)
+	for _, c := range children {
+		if c.FileType == FileTypeDirectory {
+			nlink++
+		}
+	}
+
+	var d Dirent
+	if extended {
+		d = ib.header(inodeTypeLDir, 0755, uid, uid)
+		put32(&ib.inodes, nlink)
+		put32(&ib.inodes, size)
+		put32(&ib.inodes, 0)
+		put32(&ib.inodes, 0)
+		put16(&ib.inodes, 0)
+		put16(&ib.inodes, uint16(dirOffset))
+		put32(&ib.inodes, 0xffffffff)
+	} else {
+		d = ib.header(inodeTypeDir, 0755, uid, uid)
+		put32(&ib.inodes, 0)
+		put32(&ib.inodes, nlink)
+		put16(&ib.inodes, uint16(size))
+		put16(&ib.inodes, uint16(dirOffset))
+		put32(&ib.inodes, 0)
+	}
+	return d
+}
+
+// metadata writes b as a single metadata block.
+func (ib *imageBuilder) metadata(b []byte, compressed bool) {
+	if compressed {
+		b = compress(b)
+		put16(&ib.data, uint16(len(b)))
+	} else {
+		put16(&ib.data, uint16(len(b))|metadataUncompressed)
+	}
+	ib.data.Write(b)
+}
+
+// table writes a table as a single metadata block followed by its index, and
+// returns the offset of the index.
+func (ib *imageBuilder) table(b []byte) uint64 {
+	blockOff := ib.pos()
+	ib.metadata(b, false)
+	indexOff := ib.pos()
+	put64(&ib.data, blockOff)
+	return indexOff
+}
+
+// finish returns the image, with root as the root directory.
+func (ib *imageBuilder) finish(root Dirent) []byte {
+	sb := SuperBlock{
+		Magic:             SuperBlockMagic,
+		Inodes:            ib.nextIno,
+		MkfsTime:          testMtime,
+		BlockSize:         testBlockSize,
+		Compression:       CompressionGzip,
+		BlockLog:          testBlockLog,
+		Major:             4,
+		RootInode:         root.Ref,
+		XattrIDTableStart: ^uint64(0),
+		LookupTableStart:  ^uint64(0),
+	}
+
+	var frags bytes.Buffer
+	if ib.frag.Len() > 0 {
+		put64(&frags, ib.pos())
+		put32(&frags, uint32(ib.frag.Len())|dataUncompressed)
+		put32(&frags, 0)
+		ib.data.Write(ib.frag.Bytes())
+		sb.Fragments = 1
+	}
+
+	sb.InodeTableStart = ib.pos()
+	ib.metadata(ib.inodes.Bytes(), true)
+	sb.DirectoryTableStart = ib.pos()
+	ib.metadata(ib.dirs.Bytes(), false)
+	if sb.Fragments != 0 {
+		sb.FragmentTableStart = ib.table(frags.Bytes())
+	}
+	var ids bytes.Buffer
+	for _, id := range ib.ids {
+		put32(&ids, id)
+	}
+	sb.NoIDs = uint16(len(ib.ids))
+	sb.IDTableStart = ib.table(ids.Bytes())
+	sb.BytesUsed = ib.pos()
+
+	var img bytes.Buffer
+	binary.Write(&img, binary.LittleEndian, &sb)
+	img.Write(ib.data.Bytes())
+	return img.Bytes()
+}
+
+func pattern(n int) []byte {
+	b := make([]byte, n)
+	for i := range b {
+		b[i] = byte(i % 251)
+	}
+	return b
+}
+
+var (
+	bigData    = pattern(2*testBlockSize + 100)
+	smallData  = []byte("hello world\n")
+	sparseData = append(make([]byte, testBlockSize), pattern(testBlockSize+5)...)
+	subData    = pattern(testBlockSize)
+)
+
+// testImage is the test image and the dirents of its files.
+type testImage struct {
+	image        []byte
+	root         Dirent
+	rootDirents  []Dirent
+	subDirents   []Dirent
+	big, small   Dirent
+	sparse, link Dirent
+	null, sub    Dirent
+}
+
+func newTestImage() *testImage {
+	var ib imageBuilder
+	ti := &testImage{}
+	// Big is compressed and has its tail in the fragment block after
+	// small's.
+	ti.small = ib.file(smallData, false, false, false)
+	ti.small.Name = "small"
+	ti.big = ib.file(bigData, false, true, false)
+	ti.big.Name = "big"
+	ti.sparse = ib.file(sparseData, true, false, true)
+	ti.sparse.Name = "sparse"
+	ti.link = ib.symlink("big")
+	ti.link.Name = "link"
+	ti.null = ib.chrDev(0x103)
+	ti.null.Name = "null"
+	x := ib.file(subData, false, false, false)
+	x.Name = "x"
+	ti.subDirents = []Dirent{x}
+	ti.sub = ib.dir([]Dirent{x}, true, 1000)
+	ti.sub.Name = "sub"
+	ti.rootDirents = []Dirent{ti.big, ti.link, ti.null, ti.small, ti.sparse, ti.sub}
+	ti.root = ib.dir(append([]Dirent(nil), ti.rootDirents...), false, 0)
+	ti.image = ib.finish(ti.root)
+	return ti
+}
+
+func openTestImage(t *testing.T) (*testImage, *Image) {
+	t.Helper()
+	ti := newTestImage()
+	img, err := OpenImage(bytes.NewReader(ti.image))
+	if err != nil {
+		t.Fatalf("OpenImage failed: %v", err)
+	}
+	return ti, img
+}
+
+func TestOpenImage(t *testing.T) {
+	ti, img := openTestImage(t)
+	if got := img.BlockSize(); got != testBlockSize {
+		t.Errorf("BlockSize() = %d, want %d", got, testBlockSize)
+	}
+	if got := img.RootInodeRef(); got != ti.root.Ref {
+		t.Errorf("RootInodeRef() = %d, want %d", got, ti.root.Ref)
+	}
+
+	for _, tc := range []struct {
+		name string
+		off  int
+		val  uint16
+		want error
+	}{
+		{name: "bad magic", off: 0, val: 0, want: syscall.EINVAL},
+		{name: "bad version", off: 28, val: 3, want: syscall.EINVAL},
+		{name: "bad block log", off: 22, val: 13, want: syscall.EINVAL},
+		{name: "xz", off: 20, val: CompressionXZ, want: syscall.EOPNOTSUPP},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			b := append([]byte(nil), ti.image...)
+			binary.LittleEndian.PutUint16(b[tc.off:], tc.val)
+			if _, err := OpenImage(bytes.NewReader(b)); err != tc.want {
+				t.Errorf("OpenImage() = %v, want %v", err, tc.want)
+			}
+		})
+	}
+
+	t.Run("truncated", func(t *testing.T) {
+		if _, err := OpenImage(bytes.NewReader(ti.image[:len(ti.image)-4])); err != syscall.EUCLEAN {
+			t.Errorf("OpenImage() = %v, want %v", err, syscall.EUCLEAN)
+		}
+	})
+}
+
+func TestInode(t *testing.T) {
+	ti, img := openTestImage(t)
+	for _, tc := range []struct {
+		name  string
+		d     Dirent
+		mode  uint16
+		size  uint64
+		nlink uint32
+		uid   uint32
+		rdev  uint32
+	}{
+		{name: "root", d: ti.root, mode: syscall.S_IFDIR | 0755, nlink: 3},
+		{name: "small", d: ti.small, mode: syscall.S_IFREG | 0644, size: uint64(len(smallData)), nlink: 1},
+		{name: "big", d: ti.big, mode: syscall.S_IFREG | 0644, size: uint64(len(bigData)), nlink: 1},
+		{name: "sparse", d: ti.sparse, mode: syscall.S_IFREG | 0644, size: uint64(len(sparseData)), nlink: 1},
+		{name: "link", d: ti.link, mode: syscall.S_IFLNK | 0777, size: 3, nlink: 1},
+		{name: "null", d: ti.null, mode: syscall.S_IFCHR | 0666, nlink: 1, rdev: 0x103},
+		{name: "sub", d: ti.sub, mode: syscall.S_IFDIR | 0755, nlink: 2, uid: 1000},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			in, err := img.Inode(tc.d.Ref)
+			if err != nil {
+				t.Fatalf("Inode(%d) failed: %v", tc.d.Ref, err)
+			}
+			if in.Ino != tc.d.Ino {
+				t.Errorf("Ino = %d, want %d", in.Ino, tc.d.Ino)
+			}
+			if in.Mode != tc.mode {
+				t.Errorf("Mode = %#o, want %#o", in.Mode, tc.mode)
+			}
+			if !in.IsDir() && in.Size != tc.size {
+				t.Errorf("Size = %d, want %d", in.Size, tc.size)
+			}
+			if in.Nlink != tc.nlink {
+				t.Errorf("Nlink = %d, want %d", in.Nlink, tc.nlink)
+			}
+			if in.UID != tc.uid || in.GID != tc.uid {
+				t.Errorf("UID, GID = %d, %d, want %d, %d", in.UID, in.GID, tc.uid, tc.uid)
+			}
+			if in.Rdev != tc.rdev {
+				t.Errorf("Rdev = %#x, want %#x", in.Rdev, tc.rdev)
+			}
+			if in.Mtime != testMtime {
+				t.Errorf("Mtime = %d, want %d", in.Mtime, testMtime)
+			}
+		})
+	}
+
+	if _, err := img.Inode(1 << 16); err == nil {
+		t.Errorf("Inode() of a reference past the inode table succeeded")
+	}
+}
+
+func TestReadAt(t *testing.T) {
+	ti, img := openTestImage(t)
+	for _, tc := range []struct {
+		name string
+		d    Dirent
+		want []byte
+	}{
+		{name: "small", d: ti.small, want: smallData},
+		{name: "big", d: ti.big, want: bigData},
+		{name: "sparse", d: ti.sparse, want: sparseData},
+		{name: "sub/x", d: ti.subDirents[0], want: subData},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			in, err := img.Inode(tc.d.Ref)
+			if err != nil {
+				t.Fatalf("Inode(%d) failed: %v", tc.d.Ref, err)
+			}
+			got := make([]byte, len(tc.want)+10)
+			n, err := in.ReadAt(got, 0)
+			if err != io.EOF {
+				t.Errorf("ReadAt() = %v, want %v", err, io.EOF)
+			}
+			if !bytes.Equal(got[:n], tc.want) {
+				t.Errorf("ReadAt() read %d bytes that differ from the file's data", n)
+			}
+
+			// Read across each block boundary.
+			for off := testBlockSize - 7; off < len(tc.want); off += testBlockSize {
+				got := make([]byte, 14)
+				n, err := in.ReadAt(got, int64(off))
+				want := tc.want[off:]
+				if len(want) > len(got) {
+					want = want[:len(got)]
+				}
+				if err != nil && err != io.EOF {
+					t.Errorf("ReadAt(%d) failed: %v", off, err)
+				}
+				if !bytes.Equal(got[:n], want) {
+					t.Errorf("ReadAt(%d) = %v, want %v", off, got[:n], want)
+				}
+			}
+
+			if n, err := in.ReadAt(got, int64(len(tc.want))); n != 0 || err != io.EOF {
+				t.Errorf("ReadAt() at EOF = %d, %v, want 0, %v", n, err, io.EOF)
+			}
+		})
+	}
+
+	root, err := img.Inode(ti.root.Ref)
+	if err != nil {
+		t.Fatalf("Inode() failed: %v", err)
+	}
+	if _, err := root.ReadAt(make([]byte, 1), 0); err != syscall.EINVAL {
+		t.Errorf("ReadAt() of a directory = %v, want %v", err, syscall.EINVAL)
+	}
+}
+
+func TestReadlink(t *testing.T) {
+	ti, img := openTestImage(t)
+	link, err := img.Inode(ti.link.Ref)
+	if err != nil {
+		t.Fatalf("Inode() failed: %v", err)
+	}
+	if got, err := link.Readlink(); err != nil || got != "big" {
+		t.Errorf("Readlink() = %q, %v, want %q, nil", got, err, "big")
+	}
+	big, err := img.Inode(ti.big.Ref)
+	if err != nil {
+		t.Fatalf("Inode() failed: %v", err)
+	}
+	if _, err := big.Readlink(); err != syscall.EINVAL {
+		t.Errorf("Readlink() of a regular file = %v, want %v", err, syscall.EINVAL)
+	}
+}
+
+func TestDirents(t *testing.T) {
+	ti, img := openTestImage(t)
+	for _, tc := range []struct {
+		name string
+		d    Dirent
+		want []Dirent
+	}{
+		{name: "root", d: ti.root, want: ti.rootDirents},
+		{name: "sub", d: ti.sub, want: ti.subDirents},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			in, err := img.Inode(tc.d.Ref)
+			if err != nil {
+				t.Fatalf("Inode() failed: %v", err)
+			}
+			got, err := in.Dirents()
+			if err != nil {
+				t.Fatalf("Dirents() failed: %v", err)
+			}
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("Dirents() = %+v, want %+v", got, tc.want)
+			}
+		})
+	}
+
+	small, err := img.Inode(ti.small.Ref)
+	if err != nil {
+		t.Fatalf("Inode() failed: %v", err)
+	}
+	if _, err := small.Dirents(); err != syscall.ENOTDIR {
+		t.Errorf("Dirents() of a regular file = %v, want %v", err, syscall.ENOTDIR)
+	}
+}
+
+func TestCorruptedDirent(t *testing.T) {
+	ti, img := openTestImage(t)
+	root, err := img.Inode(ti.root.Ref)
+	if err != nil {
+		t.Fatalf("Inode() failed: %v", err)
+	}
+
+	// Rename the first entry of the root directory to "..".
+	b := append([]byte(nil), ti.image...)
+	name := img.sb.DirectoryTableStart + 2 + uint64(root.dirOffset) + dirHeaderSize + direntSize
+	b[name-2] = 1
+	copy(b[name:], "..")
+	img, err = OpenImage(bytes.NewReader(b))
+	if err != nil {
+		t.Fatalf("OpenImage failed: %v", err)
+	}
+	root, err = img.Inode(ti.root.Ref)
+	if err != nil {
+		t.Fatalf("Inode() failed: %v", err)
+	}
+	if _, err := root.Dirents(); err != syscall.EUCLEAN {
+		t.Errorf("Dirents() = %v, want %v", err, syscall.EUCLEAN)
+	}
+}
@@ -0,0 +1,254 @@
+// Copyright 2020 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package statefile
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+
+	"gvisor.dev/gvisor/pkg/compressio"
+)
+
+// State files written with a key have their data encrypted with AES-256-GCM,
+// as indicated by the encryptionMetadata key. The data is preceded by a random
+// salt, from which the file's encryption key is derived, and then split into
+// chunks that are sealed separately, so that it can be streamed:
+//
+// /------------------------------------------------------\
+// |                    salt (32-bytes)                   |
+// +------------------------------------------------------+
+// |          chunk 0 length and final flag (4-bytes)     |
+// +------------------------------------------------------+
+// |                 chunk 0 ciphertext                   |
+// +------------------------------------------------------+
+// |                         ...                          |
+// \------------------------------------------------------/
+//
+// The nonce of each chunk is its index, and the final chunk, which may be
+// empty, is marked by the high bit of its length, which is authenticated; a
+// truncated file is therefore detected.
+const (
+	encryptionMetadata  = "_encryption"
+	encryptionAlgorithm = "aes-256-gcm"
+
+	saltSize           = 32
+	encryptedChunkSize = 64 * 1024
+	finalChunkFlag     = 1 << 31
+)
+
+// ErrTruncated is returned if an encrypted state file ends early.
+var ErrTruncated = errors.New("state file is truncated")
+
+// deriveKey returns a key for the given purpose derived from the state file
+// key, so that the same key isn't used by different algorithms.
+func deriveKey(key []byte, purpose string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(purpose))
+	return h.Sum(nil)
+}
+
+// newFileAEAD returns the AEAD used for the state file with the given key and
+// salt.
+func newFileAEAD(key, salt []byte) (cipher.AEAD, error) {
+	h := hmac.New(sha256.New, deriveKey(key, "statefile encryption"))
+	h.Write(salt)
+	block, err := aes.NewCipher(h.Sum(nil))
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// encryptWriter encrypts data written to it, and writes the result to w.
+type encryptWriter struct {
+	w     io.Writer
+	aead  cipher.AEAD
+	index uint64
+	buf   []byte
+}
+
+func newEncryptWriter(w io.Writer, key []byte) (*encryptWriter, error) {
+	salt := make([]byte, saltSize)
+	if _, err := io.ReadFull(rand.Reader, salt); err != nil {
+		return nil, err
+	}
+	aead, err := newFileAEAD(key, salt)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(salt); err != nil {
+		return nil, err
+	}
+	return &encryptWriter{
+		w:    w,
+		aead: aead,
+		buf:  make([]byte, 0, encryptedChunkSize),
+	}, nil
+}
+
+// Write implements io.Writer.Write.
+func (e *encryptWriter) Write(p []byte) (int, error) {
+	done := 0
+	for len(p) > 0 {
+		n := copy(e.buf[len(e.buf):cap(e.buf)], p)
+		e.buf = e.buf[:len(e.buf)+n]
+		p = p[n:]
+		done += n
+		if len(e.buf) == cap(e.buf) {
+			if err := e.seal(false /* final */); err != nil {
+				return done, err
+			}
+		}
+	}
+	return done, nil
+}
+
+// Close writes the final chunk, and closes the underlying writer if
+// necessary. It is called by compressio.Writer.Close.
+func (e *encryptWriter) Close() error {
+	if err := e.seal(true /* final */); err != nil {
+		return err
+	}
+	if closer, ok := e.w.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
+
+func (e *encryptWriter) seal(final bool) error {
+	hdr := uint32(len(e.buf) + e.aead.Overhead())
+	if final {
+		hdr |= finalChunkFlag
+	}
+	var hdrBytes [4]byte
+	binary.BigEndian.PutUint32(hdrBytes[:], hdr)
+	out := e.aead.Seal(hdrBytes[:], chunkNonce(e.aead, e.index), e.buf, hdrBytes[:])
+	e.index++
+	e.buf = e.buf[:0]
+	_, err := e.w.Write(out)
+	return err
+}
+
+// decryptReader decrypts data written by an encryptWriter.
+type decryptReader struct {
+	r     io.Reader
+	aead  cipher.AEAD
+	index uint64
+	buf   []byte
+	final bool
+
+	// err is the error that ended decryption, if any. It is kept because
+	// compressio.Reader treats errors at chunk boundaries as the end of the
+	// file.
+	err error
+}
+
+func newDecryptReader(r io.Reader, key []byte) (*decryptReader, error) {
+	salt := make([]byte, saltSize)
+	if _, err := io.ReadFull(r, salt); err != nil {
+		return nil, err
+	}
+	aead, err := newFileAEAD(key, salt)
+	if err != nil {
+		return nil, err
+	}
+	return &decryptReader{r: r, aead: aead}, nil
+}
+
+// Read implements io.Reader.Read.
+func (d *decryptReader) Read(p []byte) (int, error) {
+	for len(d.buf) == 0 {
+		if d.err != nil {
+			return 0, d.err
+		}
+		if d.final {
+			return 0, io.EOF
+		}
+		d.err = d.open()
+	}
+	n := copy(p, d.buf)
+	d.buf = d.buf[n:]
+	return n, nil
+}
+
+func (d *decryptReader) open() error {
+	var hdrBytes [4]byte
+	if _, err := io.ReadFull(d.r, hdrBytes[:]); err != nil {
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return ErrTruncated
+		}
+		return err
+	}
+	hdr := binary.BigEndian.Uint32(hdrBytes[:])
+	size := int(hdr &^ finalChunkFlag)
+	if size < d.aead.Overhead() || size > encryptedChunkSize+d.aead.Overhead() {
+		return fmt.Errorf("invalid encrypted chunk size %d", size)
+	}
+	ciphertext := make([]byte, size)
+	if _, err := io.ReadFull(d.r, ciphertext); err != nil {
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return ErrTruncated
+		}
+		return err
+	}
+	plaintext, err := d.aead.Open(ciphertext[:0], chunkNonce(d.aead, d.index), ciphertext, hdrBytes[:])
+	if err != nil {
+		return fmt.Errorf("decrypting state file: %v", err)
+	}
+	d.index++
+	d.buf = plaintext
+	d.final = hdr&finalChunkFlag != 0
+	return nil
+}
+
+// chunkNonce returns the nonce for the chunk with the given index. Since each
+// file has its own key, nonces need only be unique within a file.
+func chunkNonce(aead cipher.AEAD, index uint64) []byte {
+	nonce := make([]byte, aead.NonceSize())
+	binary.BigEndian.PutUint64(nonce[len(nonce)-8:], index)
+	return nonce
+}
+
+// encryptedReader is the wire.Reader for an encrypted state file.
+type encryptedReader struct {
+	*compressio.Reader
+	dr *decryptReader
+}
+
+// Read implements io.Reader.Read.
+func (r encryptedReader) Read(p []byte) (int, error) {
+	n, err := r.Reader.Read(p)
+	if err == io.EOF && r.dr.err != nil {
+		err = r.dr.err
+	}
+	return n, err
+}
+
+// ReadByte implements wire.Reader.ReadByte.
+func (r encryptedReader) ReadByte() (byte, error) {
+	var p [1]byte
+	n, err := r.Read(p[:])
+	if n != 1 {
+		return p[0], err
+	}
+	return p[0], nil
+}
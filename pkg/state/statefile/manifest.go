@@ -0,0 +1,107 @@
+// Copyright 2020 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package statefile
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+)
+
+// ErrManifestMismatch is returned if a state file doesn't match its manifest.
+var ErrManifestMismatch = errors.New("state file doesn't match manifest")
+
+// Manifest describes a complete state file, so that a copy of it can be
+// verified before it is loaded. Unlike the checks made while the state file is
+// read, this detects truncation and corruption before any state is restored.
+type Manifest struct {
+	// Size is the size of the state file in bytes.
+	Size int64 `json:"size"`
+
+	// SHA256 is the hex-encoded SHA-256 digest of the state file.
+	SHA256 string `json:"sha256"`
+
+	// MAC is the hex-encoded HMAC-SHA256 of the state file, if the manifest
+	// was created with a key. It prevents the state file and manifest from
+	// being replaced together by someone without the key.
+	MAC string `json:"mac,omitempty"`
+}
+
+// NewManifest returns the manifest of the state file read from r.
+func NewManifest(r io.Reader, key []byte) (*Manifest, error) {
+	var m Manifest
+	digest, mac, err := m.sum(r, key)
+	if err != nil {
+		return nil, err
+	}
+	m.SHA256 = digest
+	m.MAC = mac
+	return &m, nil
+}
+
+// Verify checks that the state file read from r matches the manifest. If key
+// is non-empty, the manifest must have been created with the same key.
+func (m *Manifest) Verify(r io.Reader, key []byte) error {
+	var got Manifest
+	digest, mac, err := got.sum(r, key)
+	if err != nil {
+		return err
+	}
+	if got.Size != m.Size {
+		return fmt.Errorf("%w: size is %d bytes, expected %d", ErrManifestMismatch, got.Size, m.Size)
+	}
+	if digest != m.SHA256 {
+		return fmt.Errorf("%w: SHA-256 is %s, expected %s", ErrManifestMismatch, digest, m.SHA256)
+	}
+	if len(key) > 0 {
+		if m.MAC == "" {
+			return fmt.Errorf("%w: manifest has no MAC", ErrManifestMismatch)
+		}
+		want, err := hex.DecodeString(m.MAC)
+		if err != nil {
+			return fmt.Errorf("%w: invalid MAC: %v", ErrManifestMismatch, err)
+		}
+		gotMAC, _ := hex.DecodeString(mac)
+		if !hmac.Equal(gotMAC, want) {
+			return fmt.Errorf("%w: MAC mismatch", ErrManifestMismatch)
+		}
+	}
+	return nil
+}
+
+// sum reads r to the end, setting m.Size and returning its hex-encoded digest
+// and, if key is non-empty, MAC.
+func (m *Manifest) sum(r io.Reader, key []byte) (string, string, error) {
+	digest := sha256.New()
+	var mac hash.Hash
+	w := io.Writer(digest)
+	if len(key) > 0 {
+		mac = hmac.New(sha256.New, deriveKey(key, "statefile manifest"))
+		w = io.MultiWriter(digest, mac)
+	}
+	n, err := io.Copy(w, r)
+	if err != nil {
+		return "", "", err
+	}
+	m.Size = n
+	if mac == nil {
+		return hex.EncodeToString(digest.Sum(nil)), "", nil
+	}
+	return hex.EncodeToString(digest.Sum(nil)), hex.EncodeToString(mac.Sum(nil)), nil
+}
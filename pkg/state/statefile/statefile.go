@@ -40,7 +40,11 @@
 // not be provided by the user. In the future, this metadata may contain some
 // information relating to the state encoding itself.
 //
-// After the map, the remainder of the file is the state data.
+// After the map, the remainder of the file is the state data. If a key is
+// provided, the state data is encrypted; see encrypt.go.
+//
+// The file is written and read strictly sequentially, so it may be streamed
+// through a pipe or socket rather than stored in a regular file.
 package statefile
 
 import (
@@ -52,6 +56,7 @@ import (
 	"fmt"
 	"hash"
 	"io"
+	"runtime"
 	"strings"
 	"time"
 
@@ -84,16 +89,107 @@ var ErrInvalidMetadataLength = fmt.Errorf("metadata length invalid, maximum size
 // ErrMetadataInvalid is returned if passed metadata is invalid.
 var ErrMetadataInvalid = fmt.Errorf("metadata invalid, can't start with _")
 
+// Compression is a state data compression setting. State files written with
+// any setting can be read by NewReader.
+type Compression string
+
+// Supported compression settings.
+const (
+	// CompressionNone stores state data without compressing it, which is
+	// fastest if the state file is written to fast local storage.
+	CompressionNone Compression = "none"
+
+	// CompressionFlateBestSpeed compresses state data with flate at its
+	// fastest level.
+	CompressionFlateBestSpeed Compression = "flate-best-speed"
+
+	// CompressionFlateDefault compresses state data with flate at its
+	// default level.
+	CompressionFlateDefault Compression = "flate-default"
+
+	// CompressionFlateBestCompression compresses state data with flate at
+	// its slowest level, for the smallest state files.
+	CompressionFlateBestCompression Compression = "flate-best-compression"
+
+	// CompressionDefault is the compression setting used if none is given.
+	// When using "best compression" mode, there is usually only a little
+	// gain in file size reduction, which translate to even smaller gain in
+	// restore latency reduction, while inccuring much more CPU usage at save
+	// time.
+	CompressionDefault = CompressionFlateBestSpeed
+)
+
+// compressionMetadata is the metadata key that records the compression
+// setting of a state file.
+const compressionMetadata = "_compression"
+
+// ParseCompression returns the Compression named by s.
+func ParseCompression(s string) (Compression, error) {
+	c := Compression(s)
+	if _, err := c.level(); err != nil {
+		return "", err
+	}
+	return c, nil
+}
+
+// level returns the flate level for c.
+func (c Compression) level() (int, error) {
+	switch c {
+	case CompressionNone:
+		return flate.NoCompression, nil
+	case CompressionFlateBestSpeed:
+		return flate.BestSpeed, nil
+	case CompressionFlateDefault:
+		return flate.DefaultCompression, nil
+	case CompressionFlateBestCompression:
+		return flate.BestCompression, nil
+	default:
+		return 0, fmt.Errorf("invalid compression %q, must be one of %q, %q, %q or %q", string(c), CompressionNone, CompressionFlateBestSpeed, CompressionFlateDefault, CompressionFlateBestCompression)
+	}
+}
+
+// WriterOpts contains options for NewWriterOpts.
+type WriterOpts struct {
+	// Compression is the compression setting. If empty, CompressionDefault
+	// is used.
+	Compression Compression
+
+	// Workers is the number of goroutines that compress state data. If
+	// zero, one more than GOMAXPROCS is used. More workers reduce the time
+	// taken to write large state files, at the cost of CPU and memory.
+	Workers int
+}
+
 // WriteCloser is an io.Closer and wire.Writer.
 type WriteCloser interface {
 	wire.Writer
 	io.Closer
 }
 
-// NewWriter returns a state data writer for a statefile.
+// NewWriter returns a state data writer for a statefile. If key is non-empty,
+// it is used to encrypt the state data as well as to check its integrity.
 //
 // Note that the returned WriteCloser must be closed.
 func NewWriter(w io.Writer, key []byte, metadata map[string]string) (WriteCloser, error) {
+	return NewWriterOpts(w, key, metadata, WriterOpts{})
+}
+
+// NewWriterOpts is equivalent to NewWriter, but with the given options.
+func NewWriterOpts(w io.Writer, key []byte, metadata map[string]string, opts WriterOpts) (WriteCloser, error) {
+	if opts.Compression == "" {
+		opts.Compression = CompressionDefault
+	}
+	level, err := opts.Compression.level()
+	if err != nil {
+		return nil, err
+	}
+	if opts.Workers < 0 {
+		return nil, fmt.Errorf("invalid number of workers %d", opts.Workers)
+	}
+	if opts.Workers == 0 {
+		opts.Workers = 1 + runtime.GOMAXPROCS(0)
+	}
+
 	if metadata == nil {
 		metadata = make(map[string]string)
 	}
@@ -116,6 +212,15 @@ func NewWriter(w io.Writer, key []byte, metadata map[string]string) (WriteCloser
 	metadata["_timestamp"] = time.Now().UTC().String()
 	defer delete(metadata, "_timestamp")
 
+	// Record the compression setting, for information only.
+	metadata[compressionMetadata] = string(opts.Compression)
+	defer delete(metadata, compressionMetadata)
+
+	if len(key) > 0 {
+		metadata[encryptionMetadata] = encryptionAlgorithm
+		defer delete(metadata, encryptionMetadata)
+	}
+
 	// Write the metadata.
 	b, err := json.Marshal(metadata)
 	if err != nil {
@@ -145,11 +250,17 @@ func NewWriter(w io.Writer, key []byte, metadata map[string]string) (WriteCloser
 		}
 	}
 
-	// Wrap in compression. We always use "best speed" mode here. When using
-	// "best compression" mode, there is usually only a little gain in file
-	// size reduction, which translate to even smaller gain in restore
-	// latency reduction, while inccuring much more CPU usage at save time.
-	return compressio.NewWriter(w, key, compressionChunkSize, flate.BestSpeed)
+	// Wrap in compression.
+	if len(key) == 0 {
+		return compressio.NewWriterWorkers(w, key, compressionChunkSize, level, opts.Workers)
+	}
+
+	// Compress before encrypting, since encrypted data is incompressible.
+	ew, err := newEncryptWriter(w, key)
+	if err != nil {
+		return nil, err
+	}
+	return compressio.NewWriterWorkers(ew, key, compressionChunkSize, level, opts.Workers)
 }
 
 // MetadataUnsafe reads out the metadata from a state file without verifying any
@@ -230,10 +341,28 @@ func NewReader(r io.Reader, key []byte) (wire.Reader, map[string]string, error)
 		return nil, nil, err
 	}
 
+	// Decrypt, if necessary. The metadata is authenticated by the key, so
+	// an encrypted file can't be passed off as unencrypted.
+	var dr *decryptReader
+	switch alg := metadata[encryptionMetadata]; alg {
+	case "":
+	case encryptionAlgorithm:
+		dr, err = newDecryptReader(r, key)
+		if err != nil {
+			return nil, nil, err
+		}
+		r = dr
+	default:
+		return nil, nil, fmt.Errorf("unsupported state file encryption %q", alg)
+	}
+
 	// Wrap in compression.
 	cr, err := compressio.NewReader(r, key)
 	if err != nil {
 		return nil, nil, err
 	}
+	if dr != nil {
+		return encryptedReader{Reader: cr, dr: dr}, metadata, nil
+	}
 	return cr, metadata, nil
 }
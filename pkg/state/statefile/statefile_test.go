@@ -18,7 +18,10 @@ import (
 	"bytes"
 	crand "crypto/rand"
 	"encoding/base64"
+	"errors"
+	"fmt"
 	"io"
+	"io/ioutil"
 	"math/rand"
 	"runtime"
 	"testing"
@@ -163,6 +166,140 @@ func TestStatefile(t *testing.T) {
 	}
 }
 
+func TestCompression(t *testing.T) {
+	data := make([]byte, 3*compressionChunkSize)
+	for i := range data {
+		data[i] = byte(i % 7)
+	}
+	for _, c := range []Compression{"", CompressionNone, CompressionFlateBestSpeed, CompressionFlateDefault, CompressionFlateBestCompression} {
+		for _, workers := range []int{0, 1, 4} {
+			t.Run(fmt.Sprintf("compression=%q,workers=%d", c, workers), func(t *testing.T) {
+				var buf bytes.Buffer
+				w, err := NewWriterOpts(&buf, nil, nil, WriterOpts{Compression: c, Workers: workers})
+				if err != nil {
+					t.Fatalf("error creating writer: %v", err)
+				}
+				if _, err := w.Write(data); err != nil {
+					t.Fatalf("error during write: %v", err)
+				}
+				if err := w.Close(); err != nil {
+					t.Fatalf("error during close: %v", err)
+				}
+				r, m, err := NewReader(bytes.NewReader(buf.Bytes()), nil)
+				if err != nil {
+					t.Fatalf("error creating reader: %v", err)
+				}
+				want := c
+				if want == "" {
+					want = CompressionDefault
+				}
+				if got := Compression(m[compressionMetadata]); got != want {
+					t.Errorf("got compression metadata %q, expected %q", got, want)
+				}
+				var decoded bytes.Buffer
+				if _, err := io.Copy(&decoded, r); err != nil {
+					t.Fatalf("error during read: %v", err)
+				}
+				if !bytes.Equal(data, decoded.Bytes()) {
+					t.Errorf("data didn't match (%d vs %d bytes)", decoded.Len(), len(data))
+				}
+			})
+		}
+	}
+
+	if _, err := ParseCompression("zstd"); err == nil {
+		t.Errorf("ParseCompression(%q) succeeded, expected error", "zstd")
+	}
+	if _, err := NewWriterOpts(new(bytes.Buffer), nil, nil, WriterOpts{Compression: "zstd"}); err == nil {
+		t.Errorf("NewWriterOpts with compression %q succeeded, expected error", "zstd")
+	}
+}
+
+func TestEncrypted(t *testing.T) {
+	key, err := randomKey()
+	if err != nil {
+		t.Fatalf("can't generate key: %v", err)
+	}
+	data := make([]byte, 3*encryptedChunkSize)
+	if _, err := crand.Read(data); err != nil {
+		t.Fatalf("can't generate data: %v", err)
+	}
+
+	var buf bytes.Buffer
+	w, err := NewWriter(&buf, key, nil)
+	if err != nil {
+		t.Fatalf("error creating writer: %v", err)
+	}
+	if _, err := w.Write(data); err != nil {
+		t.Fatalf("error during write: %v", err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("error during close: %v", err)
+	}
+	if bytes.Contains(buf.Bytes(), data[:64]) {
+		t.Errorf("state file contains plaintext data")
+	}
+
+	// The metadata can be read without verifying it, but not the data.
+	m, err := MetadataUnsafe(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatalf("error reading metadata: %v", err)
+	}
+	if got := m[encryptionMetadata]; got != encryptionAlgorithm {
+		t.Errorf("got encryption metadata %q, expected %q", got, encryptionAlgorithm)
+	}
+	if _, _, err := NewReader(bytes.NewReader(buf.Bytes()), nil); err != compressio.ErrHashMismatch {
+		t.Errorf("got error %v reading without key, expected ErrHashMismatch", err)
+	}
+
+	// Truncation at a chunk boundary is detected.
+	r, _, err := NewReader(bytes.NewReader(buf.Bytes()[:buf.Len()-(4+16)]), key)
+	if err == nil {
+		_, err = io.Copy(ioutil.Discard, r)
+	}
+	if err == nil {
+		t.Errorf("got no error: expected error on truncation")
+	}
+}
+
+func TestManifest(t *testing.T) {
+	key, err := randomKey()
+	if err != nil {
+		t.Fatalf("can't generate key: %v", err)
+	}
+	data := []byte("some state file")
+
+	for _, k := range [][]byte{nil, key} {
+		m, err := NewManifest(bytes.NewReader(data), k)
+		if err != nil {
+			t.Fatalf("error creating manifest: %v", err)
+		}
+		if m.Size != int64(len(data)) {
+			t.Errorf("got size %d, expected %d", m.Size, len(data))
+		}
+		if err := m.Verify(bytes.NewReader(data), k); err != nil {
+			t.Errorf("error verifying manifest: %v", err)
+		}
+		if err := m.Verify(bytes.NewReader(data[:len(data)-1]), k); !errors.Is(err, ErrManifestMismatch) {
+			t.Errorf("got error %v verifying truncated file, expected ErrManifestMismatch", err)
+		}
+		changed := append([]byte(nil), data...)
+		changed[0]++
+		if err := m.Verify(bytes.NewReader(changed), k); !errors.Is(err, ErrManifestMismatch) {
+			t.Errorf("got error %v verifying changed file, expected ErrManifestMismatch", err)
+		}
+	}
+
+	// A manifest without a MAC isn't accepted when a key is given.
+	m, err := NewManifest(bytes.NewReader(data), nil)
+	if err != nil {
+		t.Fatalf("error creating manifest: %v", err)
+	}
+	if err := m.Verify(bytes.NewReader(data), key); !errors.Is(err, ErrManifestMismatch) {
+		t.Errorf("got error %v verifying manifest without MAC, expected ErrManifestMismatch", err)
+	}
+}
+
 const benchmarkDataSize = 100 * 1024 * 1024
 
 func benchmark(b *testing.B, size int, write bool, compressible bool) {
@@ -51,6 +51,10 @@ func New(message string, linuxTranslation *linux.Errno) *Error {
 
 	// TODO(b/34162363): Remove this.
 	errno := linuxTranslation.Number()
+	if errno >= minInternalErrno {
+		// See ToError.
+		return err
+	}
 	if errno <= 0 || errno >= len(linuxBackwardsTranslations) {
 		panic(fmt.Sprint("invalid errno: ", errno))
 	}
@@ -101,6 +105,10 @@ func (e *Error) String() string {
 	return e.message
 }
 
+// minInternalErrno is the lowest of the errnos of include/linux/errno.h, which
+// are internal to Linux and have no host translation.
+const minInternalErrno = 512
+
 type linuxBackwardsTranslation struct {
 	err error
 	ok  bool
@@ -123,6 +131,10 @@ func (e *Error) ToError() error {
 		return nil
 	}
 	errno := e.errno.Number()
+	if errno >= minInternalErrno {
+		// Errnos internal to Linux have no other representation.
+		return syscall.Errno(errno)
+	}
 	if errno <= 0 || errno >= len(linuxBackwardsTranslations) || !linuxBackwardsTranslations[errno].ok {
 		panic(fmt.Sprintf("unknown error %q (%d)", e.message, errno))
 	}
@@ -274,6 +286,10 @@ var (
 	// ErrWouldBlock translates to EWOULDBLOCK which is the same as EAGAIN
 	// on Linux.
 	ErrWouldBlock = New("operation would block", linux.EWOULDBLOCK)
+
+	// ErrNotSupp translates to ENOTSUPP, which Linux returns instead of
+	// EOPNOTSUPP from some operations.
+	ErrNotSupp = New("operation is not supported", linux.ENOTSUPP)
 )
 
 // FromError converts a generic error to an *Error.
@@ -28,6 +28,7 @@ var (
 	E2BIG        = error(syscall.E2BIG)
 	EACCES       = error(syscall.EACCES)
 	EADDRINUSE   = error(syscall.EADDRINUSE)
+	EAFNOSUPPORT = error(syscall.EAFNOSUPPORT)
 	EAGAIN       = error(syscall.EAGAIN)
 	EBADF        = error(syscall.EBADF)
 	EBADFD       = error(syscall.EBADFD)
@@ -51,17 +52,20 @@ var (
 	EMLINK       = error(syscall.EMLINK)
 	EMSGSIZE     = error(syscall.EMSGSIZE)
 	ENAMETOOLONG = error(syscall.ENAMETOOLONG)
+	ENETUNREACH  = error(syscall.ENETUNREACH)
 	ENOATTR      = ENODATA
 	ENOBUFS      = error(syscall.ENOBUFS)
 	ENODATA      = error(syscall.ENODATA)
 	ENODEV       = error(syscall.ENODEV)
 	ENOENT       = error(syscall.ENOENT)
 	ENOEXEC      = error(syscall.ENOEXEC)
+	ENOKEY       = error(syscall.ENOKEY)
 	ENOLCK       = error(syscall.ENOLCK)
 	ENOLINK      = error(syscall.ENOLINK)
 	ENOMEM       = error(syscall.ENOMEM)
 	ENOSPC       = error(syscall.ENOSPC)
 	ENOSYS       = error(syscall.ENOSYS)
+	ENOTBLK      = error(syscall.ENOTBLK)
 	ENOTCONN     = error(syscall.ENOTCONN)
 	ENOTDIR      = error(syscall.ENOTDIR)
 	ENOTEMPTY    = error(syscall.ENOTEMPTY)
@@ -0,0 +1,336 @@
+// Copyright 2021 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dhcpv6
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"time"
+
+	"gvisor.dev/gvisor/pkg/tcpip"
+	"gvisor.dev/gvisor/pkg/tcpip/header"
+	"gvisor.dev/gvisor/pkg/tcpip/network/ipv6"
+	"gvisor.dev/gvisor/pkg/tcpip/stack"
+	"gvisor.dev/gvisor/pkg/tcpip/transport/udp"
+	"gvisor.dev/gvisor/pkg/waiter"
+)
+
+// infiniteLifetime is the lifetime value that represents infinity, as per
+// RFC 8415 section 7.7.
+const infiniteLifetime = 0xffffffff
+
+// Address is an address leased from a DHCPv6 server.
+type Address struct {
+	Address tcpip.Address
+
+	// PreferredLifetime and ValidLifetime are the lifetimes of the lease. A
+	// negative value represents an infinite lifetime.
+	PreferredLifetime time.Duration
+	ValidLifetime     time.Duration
+}
+
+// Info is the configuration obtained from a DHCPv6 server.
+type Info struct {
+	// Addresses holds the addresses leased to the client. It is empty unless
+	// addresses were requested.
+	Addresses []Address
+
+	// DNSServers holds the recursive DNS servers advertised by the server.
+	DNSServers []tcpip.Address
+
+	// DomainSearchList holds the domain search list advertised by the server.
+	DomainSearchList []string
+}
+
+// Client is a DHCPv6 client for a single NIC.
+type Client struct {
+	stack             *stack.Stack
+	nicID             tcpip.NICID
+	duid              []byte
+	retransmitTimeout time.Duration
+}
+
+// NewClient creates a DHCPv6 client for the NIC nicID, identified by a DUID
+// derived from linkAddr.
+//
+// retransmitTimeout is how long the client waits for a response before
+// retransmitting a message.
+func NewClient(s *stack.Stack, nicID tcpip.NICID, linkAddr tcpip.LinkAddress, retransmitTimeout time.Duration) *Client {
+	return &Client{
+		stack:             s,
+		nicID:             nicID,
+		duid:              LinkLayerDUID(linkAddr),
+		retransmitTimeout: retransmitTimeout,
+	}
+}
+
+// Request obtains the configuration advertised as available by an NDP Router
+// Advertisement.
+//
+// For ipv6.DHCPv6ManagedAddress, the client leases an address through a
+// Solicit/Advertise/Request/Reply exchange and assigns it to the NIC. For
+// ipv6.DHCPv6OtherConfigurations, only other configuration information is
+// requested through an Information-request/Reply exchange.
+//
+// Request blocks until the exchange completes or ctx is done.
+func (c *Client) Request(ctx context.Context, configuration ipv6.DHCPv6ConfigurationFromNDPRA) (Info, error) {
+	var wq waiter.Queue
+	ep, err := c.newEndpoint(&wq)
+	if err != nil {
+		return Info{}, err
+	}
+	defer ep.Close()
+
+	we, ch := waiter.NewChannelEntry(nil)
+	wq.EventRegister(&we, waiter.EventIn)
+	defer wq.EventUnregister(&we)
+
+	xid := c.stack.Rand().Uint32() & 0xffffff
+	oro := make([]byte, 4)
+	binary.BigEndian.PutUint16(oro, uint16(OptionDNSServers))
+	binary.BigEndian.PutUint16(oro[2:], uint16(OptionDomainList))
+	opts := Options{
+		{Code: OptionClientID, Data: c.duid},
+		{Code: OptionElapsedTime, Data: make([]byte, 2)},
+		{Code: OptionORO, Data: oro},
+	}
+
+	var reply Message
+	switch configuration {
+	case ipv6.DHCPv6NoConfiguration:
+		return Info{}, nil
+	case ipv6.DHCPv6ManagedAddress:
+		ia := IANA{IAID: uint32(c.nicID)}
+		solicit := Message{
+			Type:          MessageTypeSolicit,
+			TransactionID: xid,
+			Options:       append(opts, Option{Code: OptionIANA, Data: ia.Marshal()}),
+		}
+		advertise, err := c.exchange(ctx, ep, ch, &solicit, MessageTypeAdvertise)
+		if err != nil {
+			return Info{}, err
+		}
+		serverID, ok := advertise.Options.Get(OptionServerID)
+		if !ok {
+			return Info{}, fmt.Errorf("%s has no server identifier", advertise.Type)
+		}
+		if err := checkStatus(advertise.Options); err != nil {
+			return Info{}, err
+		}
+		iana, ok := advertise.Options.Get(OptionIANA)
+		if !ok {
+			iana = ia.Marshal()
+		}
+
+		xid = (xid + 1) & 0xffffff
+		request := Message{
+			Type:          MessageTypeRequest,
+			TransactionID: xid,
+			Options: append(opts,
+				Option{Code: OptionServerID, Data: serverID},
+				Option{Code: OptionIANA, Data: iana},
+			),
+		}
+		if reply, err = c.exchange(ctx, ep, ch, &request, MessageTypeReply); err != nil {
+			return Info{}, err
+		}
+	case ipv6.DHCPv6OtherConfigurations:
+		informationRequest := Message{
+			Type:          MessageTypeInformationRequest,
+			TransactionID: xid,
+			Options:       opts,
+		}
+		if reply, err = c.exchange(ctx, ep, ch, &informationRequest, MessageTypeReply); err != nil {
+			return Info{}, err
+		}
+	default:
+		return Info{}, fmt.Errorf("unknown DHCPv6 configuration %d", configuration)
+	}
+
+	info, err := parseReply(reply.Options)
+	if err != nil {
+		return Info{}, err
+	}
+	for _, addr := range info.Addresses {
+		protocolAddr := tcpip.ProtocolAddress{
+			Protocol: ipv6.ProtocolNumber,
+			AddressWithPrefix: tcpip.AddressWithPrefix{
+				Address:   addr.Address,
+				PrefixLen: header.IPv6AddressSize * 8,
+			},
+		}
+		if err := c.stack.AddProtocolAddress(c.nicID, protocolAddr); err != nil && err != tcpip.ErrDuplicateAddress {
+			return Info{}, fmt.Errorf("AddProtocolAddress(%d, %s): %s", c.nicID, protocolAddr.AddressWithPrefix, err)
+		}
+	}
+	return info, nil
+}
+
+// newEndpoint creates a UDP endpoint bound to the client port on the client's
+// NIC.
+func (c *Client) newEndpoint(wq *waiter.Queue) (tcpip.Endpoint, error) {
+	ep, err := c.stack.NewEndpoint(udp.ProtocolNumber, ipv6.ProtocolNumber, wq)
+	if err != nil {
+		return nil, fmt.Errorf("NewEndpoint: %s", err)
+	}
+	if err := ep.SocketOptions().SetBindToDevice(int32(c.nicID)); err != nil {
+		ep.Close()
+		return nil, fmt.Errorf("SetBindToDevice(%d): %s", c.nicID, err)
+	}
+	if err := ep.Bind(tcpip.FullAddress{Port: ClientPort}); err != nil {
+		ep.Close()
+		return nil, fmt.Errorf("Bind(port %d): %s", ClientPort, err)
+	}
+	return ep, nil
+}
+
+// exchange sends msg to all DHCPv6 servers on the link until a response of
+// type want for the same transaction is received.
+func (c *Client) exchange(ctx context.Context, ep tcpip.Endpoint, ch <-chan struct{}, msg *Message, want MessageType) (Message, error) {
+	b := msg.Marshal()
+	to := tcpip.FullAddress{
+		NIC:  c.nicID,
+		Addr: AllDHCPRelayAgentsAndServers,
+		Port: ServerPort,
+	}
+
+	for {
+		var r bytes.Reader
+		r.Reset(b)
+		if _, err := ep.Write(&r, tcpip.WriteOptions{To: &to}); err != nil {
+			return Message{}, fmt.Errorf("write %s: %s", msg.Type, err)
+		}
+
+		timer := time.NewTimer(c.retransmitTimeout)
+		resp, err := c.receive(ctx, ep, ch, timer.C, msg.TransactionID, want)
+		timer.Stop()
+		if err != nil {
+			return Message{}, err
+		}
+		if resp != nil {
+			return *resp, nil
+		}
+	}
+}
+
+// receive waits for a response of type want for the transaction xid. It
+// returns a nil message if timeout fires first.
+func (c *Client) receive(ctx context.Context, ep tcpip.Endpoint, ch <-chan struct{}, timeout <-chan time.Time, xid uint32, want MessageType) (*Message, error) {
+	for {
+		var buf bytes.Buffer
+		if _, err := ep.Read(&buf, tcpip.ReadOptions{}); err != nil {
+			if err != tcpip.ErrWouldBlock {
+				return nil, fmt.Errorf("read %s: %s", want, err)
+			}
+			select {
+			case <-ch:
+				continue
+			case <-timeout:
+				return nil, nil
+			case <-ctx.Done():
+				return nil, fmt.Errorf("waiting for %s: %w", want, ctx.Err())
+			}
+		}
+
+		msg, err := ParseMessage(buf.Bytes())
+		if err != nil {
+			// Malformed messages are silently discarded, as per RFC 8415
+			// section 16.
+			continue
+		}
+		if msg.Type != want || msg.TransactionID != xid {
+			continue
+		}
+		if clientID, ok := msg.Options.Get(OptionClientID); !ok || !bytes.Equal(clientID, c.duid) {
+			continue
+		}
+		return &msg, nil
+	}
+}
+
+// checkStatus returns an error if opts holds a Status Code option that
+// indicates a failure.
+func checkStatus(opts Options) error {
+	b, ok := opts.Get(OptionStatusCode)
+	if !ok {
+		return nil
+	}
+	code, msg, err := ParseStatusCode(b)
+	if err != nil {
+		return err
+	}
+	if code != StatusSuccess {
+		return fmt.Errorf("server returned status %d: %q", code, msg)
+	}
+	return nil
+}
+
+func lifetime(l uint32) time.Duration {
+	if l == infiniteLifetime {
+		return -1
+	}
+	return time.Duration(l) * time.Second
+}
+
+func parseReply(opts Options) (Info, error) {
+	if err := checkStatus(opts); err != nil {
+		return Info{}, err
+	}
+
+	var info Info
+	for _, b := range opts.GetAll(OptionIANA) {
+		ia, err := ParseIANA(b)
+		if err != nil {
+			return Info{}, err
+		}
+		if err := checkStatus(ia.Options); err != nil {
+			return Info{}, err
+		}
+		for _, b := range ia.Options.GetAll(OptionIAAddr) {
+			addr, err := ParseIAAddr(b)
+			if err != nil {
+				return Info{}, err
+			}
+			// Addresses with a zero valid lifetime are being withdrawn and
+			// preferred lifetimes greater than valid lifetimes are invalid, as
+			// per RFC 8415 section 21.6.
+			if addr.ValidLifetime == 0 || addr.PreferredLifetime > addr.ValidLifetime {
+				continue
+			}
+			info.Addresses = append(info.Addresses, Address{
+				Address:           addr.Address,
+				PreferredLifetime: lifetime(addr.PreferredLifetime),
+				ValidLifetime:     lifetime(addr.ValidLifetime),
+			})
+		}
+	}
+	if b, ok := opts.Get(OptionDNSServers); ok {
+		servers, err := ParseDNSServers(b)
+		if err != nil {
+			return Info{}, err
+		}
+		info.DNSServers = servers
+	}
+	if b, ok := opts.Get(OptionDomainList); ok {
+		domains, err := ParseDomainList(b)
+		if err != nil {
+			return Info{}, err
+		}
+		info.DomainSearchList = domains
+	}
+	return info, nil
+}
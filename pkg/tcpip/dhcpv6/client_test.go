@@ -0,0 +1,258 @@
+// Copyright 2021 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dhcpv6
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"gvisor.dev/gvisor/pkg/tcpip"
+	"gvisor.dev/gvisor/pkg/tcpip/header"
+	"gvisor.dev/gvisor/pkg/tcpip/link/pipe"
+	"gvisor.dev/gvisor/pkg/tcpip/network/ipv6"
+	"gvisor.dev/gvisor/pkg/tcpip/stack"
+	"gvisor.dev/gvisor/pkg/tcpip/transport/udp"
+	"gvisor.dev/gvisor/pkg/waiter"
+)
+
+const (
+	clientNICID = 1
+	serverNICID = 2
+
+	clientLinkAddr = tcpip.LinkAddress("\x02\x03\x03\x04\x05\x06")
+	serverLinkAddr = tcpip.LinkAddress("\x02\x03\x03\x04\x05\x07")
+
+	retransmitTimeout = 100 * time.Millisecond
+)
+
+var (
+	leasedAddr = tcpip.Address("\x20\x01\x0d\xb8\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x01")
+	dnsServer  = tcpip.Address("\x20\x01\x0d\xb8\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x53")
+	domains    = []string{"example.com", "corp.example.org"}
+)
+
+func TestMessageRoundTrip(t *testing.T) {
+	msg := Message{
+		Type:          MessageTypeSolicit,
+		TransactionID: 0xabcdef,
+		Options: Options{
+			{Code: OptionClientID, Data: LinkLayerDUID(clientLinkAddr)},
+			{Code: OptionElapsedTime, Data: []byte{0, 0}},
+		},
+	}
+	got, err := ParseMessage(msg.Marshal())
+	if err != nil {
+		t.Fatalf("ParseMessage(_): %s", err)
+	}
+	if diff := cmp.Diff(msg, got); diff != "" {
+		t.Errorf("message mismatch (-want +got):\n%s", diff)
+	}
+
+	if _, err := ParseMessage([]byte{1, 0, 0, 1, 0, 1, 0, 4, 0}); err == nil {
+		t.Error("ParseMessage succeeded for a truncated option")
+	}
+}
+
+func TestDomainList(t *testing.T) {
+	b := MarshalDomainList(domains)
+	got, err := ParseDomainList(b)
+	if err != nil {
+		t.Fatalf("ParseDomainList(_): %s", err)
+	}
+	if diff := cmp.Diff(domains, got); diff != "" {
+		t.Errorf("domain list mismatch (-want +got):\n%s", diff)
+	}
+
+	for _, b := range [][]byte{
+		// Unterminated name.
+		{3, 'c', 'o', 'm'},
+		// Truncated label.
+		{4, 'c', 'o', 'm', 0},
+		// Compression pointer.
+		{0xc0, 0x0c},
+	} {
+		if got, err := ParseDomainList(b); err == nil {
+			t.Errorf("ParseDomainList(%x) = (%q, nil), want error", b, got)
+		}
+	}
+}
+
+// serve answers DHCPv6 messages received by ep until ep is closed.
+func serve(t *testing.T, ep tcpip.Endpoint, ch <-chan struct{}) {
+	serverID := LinkLayerDUID(serverLinkAddr)
+	dnsOpt := []byte(dnsServer)
+	domainOpt := MarshalDomainList(domains)
+
+	for {
+		var buf bytes.Buffer
+		res, err := ep.Read(&buf, tcpip.ReadOptions{NeedRemoteAddr: true})
+		if err == tcpip.ErrWouldBlock {
+			<-ch
+			continue
+		}
+		if err != nil {
+			return
+		}
+		msg, perr := ParseMessage(buf.Bytes())
+		if perr != nil {
+			t.Errorf("ParseMessage(_): %s", perr)
+			continue
+		}
+		clientID, ok := msg.Options.Get(OptionClientID)
+		if !ok {
+			t.Errorf("%s has no client identifier", msg.Type)
+			continue
+		}
+
+		resp := Message{
+			TransactionID: msg.TransactionID,
+			Options: Options{
+				{Code: OptionClientID, Data: clientID},
+				{Code: OptionServerID, Data: serverID},
+				{Code: OptionDNSServers, Data: dnsOpt},
+				{Code: OptionDomainList, Data: domainOpt},
+			},
+		}
+		switch msg.Type {
+		case MessageTypeSolicit, MessageTypeRequest:
+			resp.Type = MessageTypeAdvertise
+			if msg.Type == MessageTypeRequest {
+				resp.Type = MessageTypeReply
+			}
+			b, _ := msg.Options.Get(OptionIANA)
+			ia, err := ParseIANA(b)
+			if err != nil {
+				t.Errorf("ParseIANA(_): %s", err)
+				continue
+			}
+			addr := IAAddr{Address: leasedAddr, PreferredLifetime: 300, ValidLifetime: infiniteLifetime}
+			ia.Options = Options{{Code: OptionIAAddr, Data: addr.Marshal()}}
+			resp.Options = append(resp.Options, Option{Code: OptionIANA, Data: ia.Marshal()})
+		case MessageTypeInformationRequest:
+			resp.Type = MessageTypeReply
+		default:
+			t.Errorf("unexpected message type %s", msg.Type)
+			continue
+		}
+
+		var r bytes.Reader
+		r.Reset(resp.Marshal())
+		if _, err := ep.Write(&r, tcpip.WriteOptions{To: &res.RemoteAddr}); err != nil {
+			t.Errorf("ep.Write(_, _): %s", err)
+		}
+	}
+}
+
+func TestClientRequest(t *testing.T) {
+	tests := []struct {
+		name          string
+		configuration ipv6.DHCPv6ConfigurationFromNDPRA
+		wantInfo      Info
+	}{
+		{
+			name:          "Managed address",
+			configuration: ipv6.DHCPv6ManagedAddress,
+			wantInfo: Info{
+				Addresses: []Address{{
+					Address:           leasedAddr,
+					PreferredLifetime: 300 * time.Second,
+					ValidLifetime:     -1,
+				}},
+				DNSServers:       []tcpip.Address{dnsServer},
+				DomainSearchList: domains,
+			},
+		},
+		{
+			name:          "Other configurations",
+			configuration: ipv6.DHCPv6OtherConfigurations,
+			wantInfo: Info{
+				DNSServers:       []tcpip.Address{dnsServer},
+				DomainSearchList: domains,
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			stackOpts := stack.Options{
+				NetworkProtocols:   []stack.NetworkProtocolFactory{ipv6.NewProtocol},
+				TransportProtocols: []stack.TransportProtocolFactory{udp.NewProtocol},
+			}
+			clientStack := stack.New(stackOpts)
+			serverStack := stack.New(stackOpts)
+			clientEP, serverEP := pipe.New(clientLinkAddr, serverLinkAddr)
+			if err := clientStack.CreateNIC(clientNICID, clientEP); err != nil {
+				t.Fatalf("clientStack.CreateNIC(%d, _): %s", clientNICID, err)
+			}
+			if err := serverStack.CreateNIC(serverNICID, serverEP); err != nil {
+				t.Fatalf("serverStack.CreateNIC(%d, _): %s", serverNICID, err)
+			}
+			if err := clientStack.AddAddress(clientNICID, ipv6.ProtocolNumber, header.LinkLocalAddr(clientLinkAddr)); err != nil {
+				t.Fatalf("clientStack.AddAddress(%d, %d, _): %s", clientNICID, ipv6.ProtocolNumber, err)
+			}
+			if err := serverStack.AddAddress(serverNICID, ipv6.ProtocolNumber, header.LinkLocalAddr(serverLinkAddr)); err != nil {
+				t.Fatalf("serverStack.AddAddress(%d, %d, _): %s", serverNICID, ipv6.ProtocolNumber, err)
+			}
+
+			var wq waiter.Queue
+			ep, err := serverStack.NewEndpoint(udp.ProtocolNumber, ipv6.ProtocolNumber, &wq)
+			if err != nil {
+				t.Fatalf("serverStack.NewEndpoint(%d, %d, _): %s", udp.ProtocolNumber, ipv6.ProtocolNumber, err)
+			}
+			addOpt := tcpip.AddMembershipOption{NIC: serverNICID, MulticastAddr: AllDHCPRelayAgentsAndServers}
+			if err := ep.SetSockOpt(&addOpt); err != nil {
+				t.Fatalf("ep.SetSockOpt(&%#v): %s", addOpt, err)
+			}
+			if err := ep.Bind(tcpip.FullAddress{Port: ServerPort}); err != nil {
+				t.Fatalf("ep.Bind(_): %s", err)
+			}
+			we, ch := waiter.NewChannelEntry(nil)
+			wq.EventRegister(&we, waiter.EventIn|waiter.EventHUp)
+			defer wq.EventUnregister(&we)
+			done := make(chan struct{})
+			go func() {
+				defer close(done)
+				serve(t, ep, ch)
+			}()
+			defer func() {
+				ep.Close()
+				<-done
+			}()
+
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			defer cancel()
+			c := NewClient(clientStack, clientNICID, clientLinkAddr, retransmitTimeout)
+			if info, err := c.Request(ctx, test.configuration); err != nil {
+				t.Fatalf("c.Request(_, %d): %s", test.configuration, err)
+			} else if diff := cmp.Diff(test.wantInfo, info); diff != "" {
+				t.Errorf("info mismatch (-want +got):\n%s", diff)
+			}
+
+			wantAddrs := len(test.wantInfo.Addresses)
+			gotAddrs := 0
+			for _, addr := range clientStack.AllAddresses()[clientNICID] {
+				if addr.AddressWithPrefix.Address == leasedAddr {
+					gotAddrs++
+				}
+			}
+			if gotAddrs != wantAddrs {
+				t.Errorf("got %d leased addresses assigned to NIC, want = %d", gotAddrs, wantAddrs)
+			}
+		})
+	}
+}
@@ -0,0 +1,394 @@
+// Copyright 2021 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package dhcpv6 implements a DHCPv6 client as specified by RFC 8415.
+//
+// Only the subset of the protocol needed to configure a netstack NIC is
+// implemented: stateful address assignment (IA_NA) and stateless
+// configuration of DNS recursive name servers and the domain search list.
+package dhcpv6
+
+import (
+	"encoding/binary"
+	"fmt"
+	"strings"
+
+	"gvisor.dev/gvisor/pkg/tcpip"
+	"gvisor.dev/gvisor/pkg/tcpip/header"
+)
+
+const (
+	// ClientPort is the UDP port DHCPv6 clients listen on.
+	ClientPort = 546
+
+	// ServerPort is the UDP port DHCPv6 servers and relay agents listen on.
+	ServerPort = 547
+)
+
+// AllDHCPRelayAgentsAndServers is the link-scoped multicast address used by a
+// client to communicate with neighboring relay agents and servers.
+const AllDHCPRelayAgentsAndServers tcpip.Address = "\xff\x02\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x01\x00\x02"
+
+// MessageType is a DHCPv6 message type, as per RFC 8415 section 7.3.
+type MessageType uint8
+
+// DHCPv6 message types.
+const (
+	MessageTypeSolicit            MessageType = 1
+	MessageTypeAdvertise          MessageType = 2
+	MessageTypeRequest            MessageType = 3
+	MessageTypeConfirm            MessageType = 4
+	MessageTypeRenew              MessageType = 5
+	MessageTypeRebind             MessageType = 6
+	MessageTypeReply              MessageType = 7
+	MessageTypeRelease            MessageType = 8
+	MessageTypeDecline            MessageType = 9
+	MessageTypeReconfigure        MessageType = 10
+	MessageTypeInformationRequest MessageType = 11
+)
+
+// String implements fmt.Stringer.
+func (t MessageType) String() string {
+	switch t {
+	case MessageTypeSolicit:
+		return "Solicit"
+	case MessageTypeAdvertise:
+		return "Advertise"
+	case MessageTypeRequest:
+		return "Request"
+	case MessageTypeConfirm:
+		return "Confirm"
+	case MessageTypeRenew:
+		return "Renew"
+	case MessageTypeRebind:
+		return "Rebind"
+	case MessageTypeReply:
+		return "Reply"
+	case MessageTypeRelease:
+		return "Release"
+	case MessageTypeDecline:
+		return "Decline"
+	case MessageTypeReconfigure:
+		return "Reconfigure"
+	case MessageTypeInformationRequest:
+		return "Information-request"
+	default:
+		return fmt.Sprintf("MessageType(%d)", uint8(t))
+	}
+}
+
+// OptionCode is a DHCPv6 option code, as per RFC 8415 section 21.
+type OptionCode uint16
+
+// DHCPv6 option codes.
+const (
+	OptionClientID    OptionCode = 1
+	OptionServerID    OptionCode = 2
+	OptionIANA        OptionCode = 3
+	OptionIAAddr      OptionCode = 5
+	OptionORO         OptionCode = 6
+	OptionElapsedTime OptionCode = 8
+	OptionStatusCode  OptionCode = 13
+	OptionRapidCommit OptionCode = 14
+	OptionDNSServers  OptionCode = 23
+	OptionDomainList  OptionCode = 24
+)
+
+// StatusCode is the status carried in a Status Code option, as per RFC 8415
+// section 21.13.
+type StatusCode uint16
+
+// DHCPv6 status codes.
+const (
+	StatusSuccess      StatusCode = 0
+	StatusUnspecFail   StatusCode = 1
+	StatusNoAddrsAvail StatusCode = 2
+	StatusNoBinding    StatusCode = 3
+	StatusNotOnLink    StatusCode = 4
+	StatusUseMulticast StatusCode = 5
+)
+
+const (
+	messageHeaderSize = 4
+	optionHeaderSize  = 4
+
+	// ianaHeaderSize is the size of the IAID, T1 and T2 fields of an IA_NA
+	// option.
+	ianaHeaderSize = 12
+
+	// iaAddrHeaderSize is the size of the address, preferred lifetime and
+	// valid lifetime fields of an IA Address option.
+	iaAddrHeaderSize = header.IPv6AddressSize + 8
+
+	// duidTypeLL is the DUID based on link-layer address type, as per RFC 8415
+	// section 11.4.
+	duidTypeLL = 3
+
+	// hardwareTypeEthernet is the IANA hardware type for Ethernet.
+	hardwareTypeEthernet = 1
+)
+
+// Option is a single DHCPv6 option.
+type Option struct {
+	Code OptionCode
+	Data []byte
+}
+
+// Options is a list of DHCPv6 options.
+type Options []Option
+
+// Get returns the data of the first option with the given code.
+func (o Options) Get(code OptionCode) ([]byte, bool) {
+	for _, opt := range o {
+		if opt.Code == code {
+			return opt.Data, true
+		}
+	}
+	return nil, false
+}
+
+// GetAll returns the data of all options with the given code.
+func (o Options) GetAll(code OptionCode) [][]byte {
+	var ret [][]byte
+	for _, opt := range o {
+		if opt.Code == code {
+			ret = append(ret, opt.Data)
+		}
+	}
+	return ret
+}
+
+func (o Options) size() int {
+	n := 0
+	for _, opt := range o {
+		n += optionHeaderSize + len(opt.Data)
+	}
+	return n
+}
+
+func (o Options) marshalInto(b []byte) int {
+	n := 0
+	for _, opt := range o {
+		binary.BigEndian.PutUint16(b[n:], uint16(opt.Code))
+		binary.BigEndian.PutUint16(b[n+2:], uint16(len(opt.Data)))
+		n += optionHeaderSize
+		n += copy(b[n:], opt.Data)
+	}
+	return n
+}
+
+// parseOptions parses a buffer holding a sequence of DHCPv6 options.
+func parseOptions(b []byte) (Options, error) {
+	var opts Options
+	for len(b) != 0 {
+		if len(b) < optionHeaderSize {
+			return nil, fmt.Errorf("truncated option header: %d bytes left", len(b))
+		}
+		code := OptionCode(binary.BigEndian.Uint16(b))
+		length := int(binary.BigEndian.Uint16(b[2:]))
+		b = b[optionHeaderSize:]
+		if len(b) < length {
+			return nil, fmt.Errorf("option %d has length %d but only %d bytes left", code, length, len(b))
+		}
+		opts = append(opts, Option{Code: code, Data: b[:length:length]})
+		b = b[length:]
+	}
+	return opts, nil
+}
+
+// Message is a DHCPv6 client/server message, as per RFC 8415 section 8.
+type Message struct {
+	Type MessageType
+
+	// TransactionID holds the 24-bit transaction ID of the message.
+	TransactionID uint32
+
+	Options Options
+}
+
+// Marshal serializes the message into its wire format.
+func (m *Message) Marshal() []byte {
+	b := make([]byte, messageHeaderSize+m.Options.size())
+	binary.BigEndian.PutUint32(b, m.TransactionID&0xffffff)
+	b[0] = uint8(m.Type)
+	m.Options.marshalInto(b[messageHeaderSize:])
+	return b
+}
+
+// ParseMessage parses a DHCPv6 client/server message.
+func ParseMessage(b []byte) (Message, error) {
+	if len(b) < messageHeaderSize {
+		return Message{}, fmt.Errorf("message too short: got %d bytes, want at least %d", len(b), messageHeaderSize)
+	}
+	opts, err := parseOptions(b[messageHeaderSize:])
+	if err != nil {
+		return Message{}, err
+	}
+	return Message{
+		Type:          MessageType(b[0]),
+		TransactionID: binary.BigEndian.Uint32(b) & 0xffffff,
+		Options:       opts,
+	}, nil
+}
+
+// LinkLayerDUID returns a DUID-LL (RFC 8415 section 11.4) for the Ethernet
+// address linkAddr.
+func LinkLayerDUID(linkAddr tcpip.LinkAddress) []byte {
+	b := make([]byte, 4+len(linkAddr))
+	binary.BigEndian.PutUint16(b, duidTypeLL)
+	binary.BigEndian.PutUint16(b[2:], hardwareTypeEthernet)
+	copy(b[4:], linkAddr)
+	return b
+}
+
+// ParseStatusCode parses the body of a Status Code option.
+func ParseStatusCode(b []byte) (StatusCode, string, error) {
+	if len(b) < 2 {
+		return 0, "", fmt.Errorf("status code option too short: %d bytes", len(b))
+	}
+	return StatusCode(binary.BigEndian.Uint16(b)), string(b[2:]), nil
+}
+
+// IANA is an Identity Association for Non-temporary Addresses option, as per
+// RFC 8415 section 21.4.
+type IANA struct {
+	IAID    uint32
+	T1      uint32
+	T2      uint32
+	Options Options
+}
+
+// Marshal serializes the body of the IA_NA option.
+func (ia *IANA) Marshal() []byte {
+	b := make([]byte, ianaHeaderSize+ia.Options.size())
+	binary.BigEndian.PutUint32(b, ia.IAID)
+	binary.BigEndian.PutUint32(b[4:], ia.T1)
+	binary.BigEndian.PutUint32(b[8:], ia.T2)
+	ia.Options.marshalInto(b[ianaHeaderSize:])
+	return b
+}
+
+// ParseIANA parses the body of an IA_NA option.
+func ParseIANA(b []byte) (IANA, error) {
+	if len(b) < ianaHeaderSize {
+		return IANA{}, fmt.Errorf("IA_NA option too short: got %d bytes, want at least %d", len(b), ianaHeaderSize)
+	}
+	opts, err := parseOptions(b[ianaHeaderSize:])
+	if err != nil {
+		return IANA{}, fmt.Errorf("IA_NA options: %w", err)
+	}
+	return IANA{
+		IAID:    binary.BigEndian.Uint32(b),
+		T1:      binary.BigEndian.Uint32(b[4:]),
+		T2:      binary.BigEndian.Uint32(b[8:]),
+		Options: opts,
+	}, nil
+}
+
+// IAAddr is an IA Address option, as per RFC 8415 section 21.6.
+type IAAddr struct {
+	Address           tcpip.Address
+	PreferredLifetime uint32
+	ValidLifetime     uint32
+	Options           Options
+}
+
+// Marshal serializes the body of the IA Address option.
+func (a *IAAddr) Marshal() []byte {
+	b := make([]byte, iaAddrHeaderSize+a.Options.size())
+	copy(b, a.Address)
+	binary.BigEndian.PutUint32(b[header.IPv6AddressSize:], a.PreferredLifetime)
+	binary.BigEndian.PutUint32(b[header.IPv6AddressSize+4:], a.ValidLifetime)
+	a.Options.marshalInto(b[iaAddrHeaderSize:])
+	return b
+}
+
+// ParseIAAddr parses the body of an IA Address option.
+func ParseIAAddr(b []byte) (IAAddr, error) {
+	if len(b) < iaAddrHeaderSize {
+		return IAAddr{}, fmt.Errorf("IA Address option too short: got %d bytes, want at least %d", len(b), iaAddrHeaderSize)
+	}
+	opts, err := parseOptions(b[iaAddrHeaderSize:])
+	if err != nil {
+		return IAAddr{}, fmt.Errorf("IA Address options: %w", err)
+	}
+	return IAAddr{
+		Address:           tcpip.Address(b[:header.IPv6AddressSize]),
+		PreferredLifetime: binary.BigEndian.Uint32(b[header.IPv6AddressSize:]),
+		ValidLifetime:     binary.BigEndian.Uint32(b[header.IPv6AddressSize+4:]),
+		Options:           opts,
+	}, nil
+}
+
+// ParseDNSServers parses the body of a DNS Recursive Name Server option, as
+// per RFC 3646 section 3.
+func ParseDNSServers(b []byte) ([]tcpip.Address, error) {
+	if len(b)%header.IPv6AddressSize != 0 {
+		return nil, fmt.Errorf("DNS servers option length %d is not a multiple of %d", len(b), header.IPv6AddressSize)
+	}
+	addrs := make([]tcpip.Address, 0, len(b)/header.IPv6AddressSize)
+	for len(b) != 0 {
+		addrs = append(addrs, tcpip.Address(b[:header.IPv6AddressSize]))
+		b = b[header.IPv6AddressSize:]
+	}
+	return addrs, nil
+}
+
+// ParseDomainList parses the body of a Domain Search List option, as per
+// RFC 3646 section 4. Names are encoded as specified by RFC 1035 section 3.1
+// without compression.
+func ParseDomainList(b []byte) ([]string, error) {
+	var domains []string
+	var labels []string
+	for len(b) != 0 {
+		l := int(b[0])
+		b = b[1:]
+		if l == 0 {
+			if len(labels) == 0 {
+				return nil, fmt.Errorf("empty domain name")
+			}
+			domains = append(domains, strings.Join(labels, "."))
+			labels = labels[:0]
+			continue
+		}
+		// The top two bits indicate a compression pointer, which is not
+		// permitted in DHCPv6.
+		if l&0xc0 != 0 {
+			return nil, fmt.Errorf("invalid label length %d", l)
+		}
+		if len(b) < l {
+			return nil, fmt.Errorf("label of length %d truncated to %d bytes", l, len(b))
+		}
+		labels = append(labels, string(b[:l]))
+		b = b[l:]
+	}
+	if len(labels) != 0 {
+		return nil, fmt.Errorf("domain name %q is not terminated", strings.Join(labels, "."))
+	}
+	return domains, nil
+}
+
+// MarshalDomainList serializes domains into the body of a Domain Search List
+// option.
+func MarshalDomainList(domains []string) []byte {
+	var b []byte
+	for _, d := range domains {
+		for _, label := range strings.Split(strings.TrimSuffix(d, "."), ".") {
+			b = append(b, uint8(len(label)))
+			b = append(b, label...)
+		}
+		b = append(b, 0)
+	}
+	return b
+}
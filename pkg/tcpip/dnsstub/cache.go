@@ -0,0 +1,131 @@
+// Copyright 2021 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dnsstub
+
+import (
+	"container/list"
+	"encoding/binary"
+	"time"
+
+	"gvisor.dev/gvisor/pkg/sync"
+	"gvisor.dev/gvisor/pkg/tcpip"
+)
+
+// maxCacheTTL is the longest time a response is cached for, regardless of
+// the TTLs of its records.
+const maxCacheTTL = 24 * 60 * 60
+
+// cacheEntry is a cached response.
+type cacheEntry struct {
+	question   question
+	msg        []byte
+	ttlOffsets []int
+
+	// added is the monotonic time the response was cached at.
+	added int64
+
+	// expires is the monotonic time the response expires at.
+	expires int64
+}
+
+// cache is a least recently used cache of DNS responses. Responses expire
+// with the record that has the smallest TTL.
+type cache struct {
+	clock tcpip.Clock
+	size  int
+
+	mu sync.Mutex
+	// entries maps questions to their element in lru. It is protected by mu.
+	entries map[question]*list.Element
+	// lru holds the cached entries from the most recently used. It is
+	// protected by mu.
+	lru list.List
+}
+
+func newCache(clock tcpip.Clock, size int) *cache {
+	return &cache{
+		clock:   clock,
+		size:    size,
+		entries: make(map[question]*list.Element),
+	}
+}
+
+// lookup returns the cached response to q, with the id and TTLs reduced by the
+// time spent in the cache, or nil if there is none.
+func (c *cache) lookup(q question, id uint16) []byte {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	elem, ok := c.entries[q]
+	if !ok {
+		return nil
+	}
+	e := elem.Value.(*cacheEntry)
+	now := c.clock.NowMonotonic()
+	if now >= e.expires {
+		c.lru.Remove(elem)
+		delete(c.entries, q)
+		return nil
+	}
+	c.lru.MoveToFront(elem)
+
+	elapsed := uint32((now - e.added) / int64(time.Second))
+	msg := append([]byte(nil), e.msg...)
+	binary.BigEndian.PutUint16(msg, id)
+	for _, off := range e.ttlOffsets {
+		ttl := binary.BigEndian.Uint32(msg[off:])
+		if ttl > elapsed {
+			ttl -= elapsed
+		} else {
+			ttl = 0
+		}
+		binary.BigEndian.PutUint32(msg[off:], ttl)
+	}
+	return msg
+}
+
+// insert caches msg, the parsed response r, as the response to q.
+func (c *cache) insert(q question, r *response, msg []byte) {
+	if c.size <= 0 || !r.cacheable() {
+		return
+	}
+	ttl := r.minTTL
+	if ttl > maxCacheTTL {
+		ttl = maxCacheTTL
+	}
+	now := c.clock.NowMonotonic()
+	e := &cacheEntry{
+		question:   q,
+		msg:        append([]byte(nil), msg...),
+		ttlOffsets: r.ttlOffsets,
+		added:      now,
+		expires:    now + int64(ttl)*int64(time.Second),
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if elem, ok := c.entries[q]; ok {
+		elem.Value = e
+		c.lru.MoveToFront(elem)
+		return
+	}
+	c.entries[q] = c.lru.PushFront(e)
+	if c.lru.Len() > c.size {
+		oldest := c.lru.Back()
+		c.lru.Remove(oldest)
+		delete(c.entries, oldest.Value.(*cacheEntry).question)
+	}
+}
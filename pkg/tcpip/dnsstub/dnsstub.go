@@ -0,0 +1,499 @@
+// Copyright 2021 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package dnsstub implements a caching DNS stub resolver served by a netstack
+// stack.
+//
+// The resolver answers the queries it receives over UDP and TCP from its
+// cache, or by forwarding them to upstream servers over UDP, TCP or
+// DNS-over-TLS (RFC 7858). Queries for the domains of conditional forwarding
+// rules are forwarded to the servers of the rule instead of the default ones.
+package dnsstub
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"gvisor.dev/gvisor/pkg/log"
+	"gvisor.dev/gvisor/pkg/sync"
+	"gvisor.dev/gvisor/pkg/tcpip"
+	"gvisor.dev/gvisor/pkg/tcpip/adapters/gonet"
+	"gvisor.dev/gvisor/pkg/tcpip/header"
+	"gvisor.dev/gvisor/pkg/tcpip/stack"
+)
+
+const (
+	// DefaultAddr is the address the resolver listens on by default, the
+	// address of the systemd-resolved stub listener.
+	DefaultAddr = tcpip.Address("\x7f\x00\x00\x35")
+
+	// Port is the DNS port.
+	Port = 53
+
+	// TLSPort is the DNS-over-TLS port.
+	TLSPort = 853
+
+	// DefaultTimeout is the default time an upstream server has to answer
+	// a query.
+	DefaultTimeout = 5 * time.Second
+
+	// tcpIdleTimeout is the time after which idle TCP connections from
+	// clients are closed.
+	tcpIdleTimeout = 10 * time.Second
+)
+
+// Upstream is a DNS server queries are forwarded to.
+type Upstream struct {
+	// Addr is the address of the server.
+	Addr tcpip.FullAddress
+
+	// TLS indicates that queries are sent with DNS-over-TLS.
+	TLS bool
+
+	// ServerName is the name the certificate of a DNS-over-TLS server is
+	// verified against.
+	ServerName string
+}
+
+// String implements fmt.Stringer.
+func (u Upstream) String() string {
+	addr := net.JoinHostPort(net.IP(u.Addr.Addr).String(), strconv.Itoa(int(u.Addr.Port)))
+	if u.TLS {
+		return fmt.Sprintf("tls://%s#%s", addr, u.ServerName)
+	}
+	return addr
+}
+
+// ParseUpstream parses an upstream server of the form "addr[:port]" or
+// "tls://addr[:port][#name]". IPv6 addresses with a port are enclosed in
+// brackets. The port defaults to 53, or 853 for DNS-over-TLS, and the name
+// the certificate is verified against defaults to the address.
+func ParseUpstream(s string) (Upstream, error) {
+	var u Upstream
+	hostport := s
+	port := uint16(Port)
+	if strings.HasPrefix(hostport, "tls://") {
+		hostport = hostport[len("tls://"):]
+		u.TLS = true
+		port = TLSPort
+		if i := strings.IndexByte(hostport, '#'); i >= 0 {
+			u.ServerName = hostport[i+1:]
+			hostport = hostport[:i]
+		}
+	}
+
+	host := strings.TrimSuffix(strings.TrimPrefix(hostport, "["), "]")
+	if h, p, err := net.SplitHostPort(hostport); err == nil {
+		n, err := strconv.ParseUint(p, 10, 16)
+		if err != nil || n == 0 {
+			return Upstream{}, fmt.Errorf("invalid port in DNS server %q", s)
+		}
+		host = h
+		port = uint16(n)
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return Upstream{}, fmt.Errorf("invalid address in DNS server %q", s)
+	}
+	if ip4 := ip.To4(); ip4 != nil {
+		ip = ip4
+	}
+	u.Addr = tcpip.FullAddress{Addr: tcpip.Address(ip), Port: port}
+	if u.TLS && u.ServerName == "" {
+		u.ServerName = host
+	}
+	return u, nil
+}
+
+// ParseUpstreams parses a comma-separated list of upstream servers, as
+// accepted by ParseUpstream.
+func ParseUpstreams(s string) ([]Upstream, error) {
+	var upstreams []Upstream
+	for _, f := range strings.Split(s, ",") {
+		if f = strings.TrimSpace(f); f == "" {
+			continue
+		}
+		u, err := ParseUpstream(f)
+		if err != nil {
+			return nil, err
+		}
+		upstreams = append(upstreams, u)
+	}
+	return upstreams, nil
+}
+
+// Forward is a conditional forwarding rule.
+type Forward struct {
+	// Domain is the domain, in lower case and fully qualified, whose names
+	// are resolved by Upstreams.
+	Domain string
+
+	// Upstreams are the servers queries are forwarded to.
+	Upstreams []Upstream
+}
+
+// ParseForwards parses a comma-separated list of conditional forwarding rules
+// of the form "domain=server", where server is as accepted by ParseUpstream.
+// Rules for the same domain are merged.
+func ParseForwards(s string) ([]Forward, error) {
+	var forwards []Forward
+	indexes := make(map[string]int)
+	for _, f := range strings.Split(s, ",") {
+		if f = strings.TrimSpace(f); f == "" {
+			continue
+		}
+		i := strings.IndexByte(f, '=')
+		if i < 0 {
+			return nil, fmt.Errorf("invalid DNS forwarding rule %q, want domain=server", f)
+		}
+		domain := canonicalName(f[:i])
+		u, err := ParseUpstream(f[i+1:])
+		if err != nil {
+			return nil, err
+		}
+		idx, ok := indexes[domain]
+		if !ok {
+			idx = len(forwards)
+			indexes[domain] = idx
+			forwards = append(forwards, Forward{Domain: domain})
+		}
+		forwards[idx].Upstreams = append(forwards[idx].Upstreams, u)
+	}
+	return forwards, nil
+}
+
+// canonicalName returns name in lower case and fully qualified.
+func canonicalName(name string) string {
+	name = strings.ToLower(name)
+	if !strings.HasSuffix(name, ".") {
+		name += "."
+	}
+	return name
+}
+
+// Config is the configuration of a resolver.
+type Config struct {
+	// Addr is the address the resolver listens on, which must be assigned
+	// to a NIC of the stack. It defaults to DefaultAddr.
+	Addr tcpip.Address
+
+	// Port is the port the resolver listens on. It defaults to Port.
+	Port uint16
+
+	// Upstreams are the servers queries are forwarded to by default, in
+	// order of preference.
+	Upstreams []Upstream
+
+	// Forwards are the conditional forwarding rules. The rule with the
+	// longest domain matching the name of a query applies.
+	Forwards []Forward
+
+	// CacheSize is the maximum number of cached responses. Responses are
+	// not cached if it is zero.
+	CacheSize int
+
+	// Timeout is the time an upstream server has to answer a query. It
+	// defaults to DefaultTimeout.
+	Timeout time.Duration
+
+	// RootCAs are the certificate authorities DNS-over-TLS servers are
+	// verified with. The system ones are used if it is nil.
+	RootCAs *x509.CertPool
+}
+
+// UsesTLS returns whether any upstream server uses DNS-over-TLS.
+func (c *Config) UsesTLS() bool {
+	for _, u := range c.Upstreams {
+		if u.TLS {
+			return true
+		}
+	}
+	for _, f := range c.Forwards {
+		for _, u := range f.Upstreams {
+			if u.TLS {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Server is a DNS stub resolver.
+type Server struct {
+	stack  *stack.Stack
+	config Config
+	cache  *cache
+
+	udp *gonet.UDPConn
+	tcp *gonet.TCPListener
+
+	// wg is used to wait for the goroutines serving queries.
+	wg sync.WaitGroup
+}
+
+// New starts a resolver listening on s.
+func New(s *stack.Stack, config Config) (*Server, error) {
+	if config.Addr == "" {
+		config.Addr = DefaultAddr
+	}
+	if config.Port == 0 {
+		config.Port = Port
+	}
+	if config.Timeout == 0 {
+		config.Timeout = DefaultTimeout
+	}
+	if len(config.Upstreams) == 0 && len(config.Forwards) == 0 {
+		return nil, errors.New("no upstream DNS server")
+	}
+
+	addr := tcpip.FullAddress{Addr: config.Addr, Port: config.Port}
+	netProto := networkProtocol(config.Addr)
+	udp, err := gonet.DialUDP(s, &addr, nil, netProto)
+	if err != nil {
+		return nil, fmt.Errorf("listening on UDP %s:%d: %v", config.Addr, config.Port, err)
+	}
+	tcp, err := gonet.ListenTCP(s, addr, netProto)
+	if err != nil {
+		udp.Close()
+		return nil, fmt.Errorf("listening on TCP %s:%d: %v", config.Addr, config.Port, err)
+	}
+
+	srv := &Server{
+		stack:  s,
+		config: config,
+		cache:  newCache(s.Clock(), config.CacheSize),
+		udp:    udp,
+		tcp:    tcp,
+	}
+	srv.wg.Add(2)
+	go func() {
+		defer srv.wg.Done()
+		srv.serveUDP()
+	}()
+	go func() {
+		defer srv.wg.Done()
+		srv.serveTCP()
+	}()
+	return srv, nil
+}
+
+// Close stops the resolver and waits for the queries being answered.
+func (srv *Server) Close() {
+	srv.udp.Close()
+	srv.tcp.Close()
+	srv.wg.Wait()
+}
+
+// networkProtocol returns the network protocol of addr.
+func networkProtocol(addr tcpip.Address) tcpip.NetworkProtocolNumber {
+	if len(addr) == header.IPv6AddressSize {
+		return header.IPv6ProtocolNumber
+	}
+	return header.IPv4ProtocolNumber
+}
+
+// serveUDP answers the queries received over UDP until the UDP endpoint is
+// closed.
+func (srv *Server) serveUDP() {
+	for {
+		buf := make([]byte, header.UDPMaximumPacketSize)
+		n, addr, err := srv.udp.ReadFrom(buf)
+		if err != nil {
+			if opErr, ok := err.(*net.OpError); ok && opErr.Temporary() {
+				continue
+			}
+			return
+		}
+		srv.wg.Add(1)
+		go func() {
+			defer srv.wg.Done()
+			msg := buf[:n]
+			q, err := parseQuery(msg)
+			if err != nil {
+				// Malformed queries are dropped.
+				return
+			}
+			resp := srv.resolve(msg, &q)
+			if len(resp) > q.udpSize {
+				// The client retries over TCP.
+				resp = emptyResponse(msg, &q, uint8(binary.BigEndian.Uint16(resp[2:])&rcodeMask), flagTruncated)
+			}
+			if _, err := srv.udp.WriteTo(resp, addr); err != nil {
+				log.Debugf("DNS stub: sending response to %s: %v", addr, err)
+			}
+		}()
+	}
+}
+
+// serveTCP accepts TCP connections until the listener is closed.
+func (srv *Server) serveTCP() {
+	for {
+		conn, err := srv.tcp.Accept()
+		if err != nil {
+			return
+		}
+		srv.wg.Add(1)
+		go func() {
+			defer srv.wg.Done()
+			defer conn.Close()
+			srv.serveConn(conn)
+		}()
+	}
+}
+
+// serveConn answers the queries received on a TCP connection until it is
+// closed or idle.
+func (srv *Server) serveConn(conn net.Conn) {
+	for {
+		conn.SetDeadline(time.Now().Add(tcpIdleTimeout))
+		msg, err := readTCPMessage(conn)
+		if err != nil {
+			return
+		}
+		q, err := parseQuery(msg)
+		if err != nil {
+			return
+		}
+		if err := writeTCPMessage(conn, srv.resolve(msg, &q)); err != nil {
+			return
+		}
+	}
+}
+
+// readTCPMessage reads a DNS message prefixed by its length, as sent over
+// TCP.
+func readTCPMessage(r io.Reader) ([]byte, error) {
+	var l [2]byte
+	if _, err := io.ReadFull(r, l[:]); err != nil {
+		return nil, err
+	}
+	msg := make([]byte, binary.BigEndian.Uint16(l[:]))
+	if _, err := io.ReadFull(r, msg); err != nil {
+		return nil, err
+	}
+	return msg, nil
+}
+
+// writeTCPMessage writes a DNS message prefixed by its length, as sent over
+// TCP.
+func writeTCPMessage(w io.Writer, msg []byte) error {
+	b := make([]byte, 2, 2+len(msg))
+	binary.BigEndian.PutUint16(b, uint16(len(msg)))
+	_, err := w.Write(append(b, msg...))
+	return err
+}
+
+// upstreamsFor returns the servers queries for name are forwarded to.
+func (srv *Server) upstreamsFor(name string) []Upstream {
+	upstreams := srv.config.Upstreams
+	matched := ""
+	for _, f := range srv.config.Forwards {
+		if len(f.Domain) <= len(matched) {
+			continue
+		}
+		if f.Domain == "." || name == f.Domain || strings.HasSuffix(name, "."+f.Domain) {
+			upstreams = f.Upstreams
+			matched = f.Domain
+		}
+	}
+	return upstreams
+}
+
+// resolve returns the response to the query msg, parsed as q.
+func (srv *Server) resolve(msg []byte, q *query) []byte {
+	if resp := srv.cache.lookup(q.question, q.id); resp != nil {
+		return resp
+	}
+
+	for _, u := range srv.upstreamsFor(q.question.name) {
+		resp, err := srv.exchange(u, msg)
+		if err != nil {
+			log.Debugf("DNS stub: resolving %s with %s: %v", q.question, u, err)
+			continue
+		}
+		r, err := parseResponse(resp)
+		if err != nil || r.id != q.id || r.question != q.question {
+			log.Debugf("DNS stub: invalid response to %s from %s", q.question, u)
+			continue
+		}
+		if r.rcode == rcodeServerFailure {
+			continue
+		}
+		srv.cache.insert(q.question, &r, resp)
+		return resp
+	}
+	return emptyResponse(msg, q, rcodeServerFailure, 0)
+}
+
+// exchange sends the query msg to u and returns its response.
+func (srv *Server) exchange(u Upstream, msg []byte) ([]byte, error) {
+	if u.TLS {
+		return srv.exchangeTCP(u, msg)
+	}
+
+	conn, err := gonet.DialUDP(srv.stack, nil, &u.Addr, networkProtocol(u.Addr.Addr))
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(srv.config.Timeout))
+	if _, err := conn.Write(msg); err != nil {
+		return nil, err
+	}
+	buf := make([]byte, header.UDPMaximumPacketSize)
+	n, err := conn.Read(buf)
+	if err != nil {
+		return nil, err
+	}
+	resp := buf[:n]
+	if len(resp) >= headerSize && binary.BigEndian.Uint16(resp[2:])&flagTruncated != 0 {
+		return srv.exchangeTCP(u, msg)
+	}
+	return resp, nil
+}
+
+// exchangeTCP sends the query msg to u over TCP, or DNS-over-TLS, and returns
+// its response.
+func (srv *Server) exchangeTCP(u Upstream, msg []byte) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), srv.config.Timeout)
+	defer cancel()
+	tcpConn, err := gonet.DialContextTCP(ctx, srv.stack, u.Addr, networkProtocol(u.Addr.Addr))
+	if err != nil {
+		return nil, err
+	}
+	var conn net.Conn = tcpConn
+	if u.TLS {
+		conn = tls.Client(tcpConn, &tls.Config{
+			ServerName: u.ServerName,
+			RootCAs:    srv.config.RootCAs,
+		})
+	}
+	defer conn.Close()
+	deadline, _ := ctx.Deadline()
+	conn.SetDeadline(deadline)
+
+	if err := writeTCPMessage(conn, msg); err != nil {
+		return nil, err
+	}
+	return readTCPMessage(conn)
+}
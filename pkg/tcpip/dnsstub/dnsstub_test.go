@@ -0,0 +1,311 @@
+// Copyright 2021 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dnsstub
+
+import (
+	"encoding/binary"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"gvisor.dev/gvisor/pkg/tcpip"
+	"gvisor.dev/gvisor/pkg/tcpip/adapters/gonet"
+	"gvisor.dev/gvisor/pkg/tcpip/faketime"
+	"gvisor.dev/gvisor/pkg/tcpip/header"
+	"gvisor.dev/gvisor/pkg/tcpip/link/loopback"
+	"gvisor.dev/gvisor/pkg/tcpip/network/ipv4"
+	"gvisor.dev/gvisor/pkg/tcpip/stack"
+	"gvisor.dev/gvisor/pkg/tcpip/transport/tcp"
+	"gvisor.dev/gvisor/pkg/tcpip/transport/udp"
+)
+
+const (
+	nicID = 1
+
+	typeA   = 1
+	classIN = 1
+	ttl     = 60
+)
+
+var (
+	upstreamAddr = tcpip.Address("\x7f\x00\x00\x01")
+	answerAddr   = []byte{192, 0, 2, 1}
+)
+
+func TestParseUpstream(t *testing.T) {
+	tests := []struct {
+		s    string
+		want Upstream
+	}{
+		{
+			s:    "192.0.2.1",
+			want: Upstream{Addr: tcpip.FullAddress{Addr: "\xc0\x00\x02\x01", Port: Port}},
+		},
+		{
+			s:    "192.0.2.1:5353",
+			want: Upstream{Addr: tcpip.FullAddress{Addr: "\xc0\x00\x02\x01", Port: 5353}},
+		},
+		{
+			s:    "[2001:db8::1]:5353",
+			want: Upstream{Addr: tcpip.FullAddress{Addr: "\x20\x01\x0d\xb8\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x01", Port: 5353}},
+		},
+		{
+			s:    "2001:db8::1",
+			want: Upstream{Addr: tcpip.FullAddress{Addr: "\x20\x01\x0d\xb8\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x01", Port: Port}},
+		},
+		{
+			s:    "tls://192.0.2.1#dns.example.com",
+			want: Upstream{Addr: tcpip.FullAddress{Addr: "\xc0\x00\x02\x01", Port: TLSPort}, TLS: true, ServerName: "dns.example.com"},
+		},
+		{
+			s:    "tls://192.0.2.1:8853",
+			want: Upstream{Addr: tcpip.FullAddress{Addr: "\xc0\x00\x02\x01", Port: 8853}, TLS: true, ServerName: "192.0.2.1"},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.s, func(t *testing.T) {
+			got, err := ParseUpstream(test.s)
+			if err != nil {
+				t.Fatalf("ParseUpstream(%q): %s", test.s, err)
+			}
+			if diff := cmp.Diff(test.want, got); diff != "" {
+				t.Errorf("upstream mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+
+	for _, s := range []string{"", "dns.example.com", "192.0.2.1:0", "192.0.2.1:dns", "tls://#dns.example.com"} {
+		if got, err := ParseUpstream(s); err == nil {
+			t.Errorf("ParseUpstream(%q) = (%s, nil), want error", s, got)
+		}
+	}
+}
+
+func TestParseForwards(t *testing.T) {
+	got, err := ParseForwards("corp.example.com=192.0.2.1, Corp.Example.com.=192.0.2.2,.=192.0.2.3")
+	if err != nil {
+		t.Fatalf("ParseForwards(_): %s", err)
+	}
+	want := []Forward{
+		{
+			Domain: "corp.example.com.",
+			Upstreams: []Upstream{
+				{Addr: tcpip.FullAddress{Addr: "\xc0\x00\x02\x01", Port: Port}},
+				{Addr: tcpip.FullAddress{Addr: "\xc0\x00\x02\x02", Port: Port}},
+			},
+		},
+		{
+			Domain:    ".",
+			Upstreams: []Upstream{{Addr: tcpip.FullAddress{Addr: "\xc0\x00\x02\x03", Port: Port}}},
+		},
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("forwards mismatch (-want +got):\n%s", diff)
+	}
+
+	if got, err := ParseForwards("corp.example.com"); err == nil {
+		t.Errorf("ParseForwards(_) = (%v, nil), want error", got)
+	}
+}
+
+// newQuery returns a query for the A records of name.
+func newQuery(id uint16, name string) []byte {
+	msg := make([]byte, headerSize)
+	binary.BigEndian.PutUint16(msg, id)
+	binary.BigEndian.PutUint16(msg[2:], flagRecursionDesired)
+	binary.BigEndian.PutUint16(msg[4:], 1)
+	for _, l := range strings.Split(strings.TrimSuffix(name, "."), ".") {
+		msg = append(msg, byte(len(l)))
+		msg = append(msg, l...)
+	}
+	msg = append(msg, 0, 0, typeA, 0, classIN)
+	return msg
+}
+
+// serve answers the queries received by conn with an A record, and counts
+// them in queries.
+func serve(conn *gonet.UDPConn, queries *int32) {
+	for {
+		buf := make([]byte, header.UDPMaximumPacketSize)
+		n, addr, err := conn.ReadFrom(buf)
+		if err != nil {
+			return
+		}
+		atomic.AddInt32(queries, 1)
+		msg := buf[:n]
+		q, err := parseQuery(msg)
+		if err != nil {
+			continue
+		}
+		resp := emptyResponse(msg, &q, rcodeSuccess, 0)
+		binary.BigEndian.PutUint16(resp[6:], 1)
+		// The answer refers to the name of the question.
+		resp = append(resp, 0xc0, headerSize, 0, typeA, 0, classIN)
+		resp = append(resp, 0, 0, 0, ttl, 0, byte(len(answerAddr)))
+		resp = append(resp, answerAddr...)
+		conn.WriteTo(resp, addr)
+	}
+}
+
+// newUpstream starts a server on port that answers all queries, and returns
+// the number of queries it received.
+func newUpstream(t *testing.T, s *stack.Stack, port uint16) *int32 {
+	t.Helper()
+
+	conn, err := gonet.DialUDP(s, &tcpip.FullAddress{Addr: upstreamAddr, Port: port}, nil, ipv4.ProtocolNumber)
+	if err != nil {
+		t.Fatalf("gonet.DialUDP(_, _, nil, %d): %s", ipv4.ProtocolNumber, err)
+	}
+	var queries int32
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		serve(conn, &queries)
+	}()
+	t.Cleanup(func() {
+		conn.Close()
+		<-done
+	})
+	return &queries
+}
+
+func newStack(t *testing.T, clock tcpip.Clock) *stack.Stack {
+	t.Helper()
+
+	s := stack.New(stack.Options{
+		NetworkProtocols:   []stack.NetworkProtocolFactory{ipv4.NewProtocol},
+		TransportProtocols: []stack.TransportProtocolFactory{tcp.NewProtocol, udp.NewProtocol},
+		Clock:              clock,
+	})
+	if err := s.CreateNIC(nicID, loopback.New()); err != nil {
+		t.Fatalf("s.CreateNIC(%d, _): %s", nicID, err)
+	}
+	for _, addr := range []tcpip.Address{upstreamAddr, DefaultAddr} {
+		if err := s.AddAddress(nicID, ipv4.ProtocolNumber, addr); err != nil {
+			t.Fatalf("s.AddAddress(%d, %d, %s): %s", nicID, ipv4.ProtocolNumber, addr, err)
+		}
+	}
+	s.SetRouteTable([]tcpip.Route{{Destination: header.IPv4EmptySubnet, NIC: nicID}})
+	return s
+}
+
+func newServer(t *testing.T, s *stack.Stack, config Config) {
+	t.Helper()
+
+	srv, err := New(s, config)
+	if err != nil {
+		t.Fatalf("New(_, %#v): %s", config, err)
+	}
+	t.Cleanup(srv.Close)
+}
+
+// lookup sends a query for name to the resolver and returns its response.
+func lookup(t *testing.T, s *stack.Stack, id uint16, name string) response {
+	t.Helper()
+
+	conn, err := gonet.DialUDP(s, nil, &tcpip.FullAddress{Addr: DefaultAddr, Port: Port}, ipv4.ProtocolNumber)
+	if err != nil {
+		t.Fatalf("gonet.DialUDP(_, nil, _, %d): %s", ipv4.ProtocolNumber, err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	if _, err := conn.Write(newQuery(id, name)); err != nil {
+		t.Fatalf("conn.Write(_): %s", err)
+	}
+	buf := make([]byte, maxUDPSize)
+	n, err := conn.Read(buf)
+	if err != nil {
+		t.Fatalf("conn.Read(_): %s", err)
+	}
+	r, err := parseResponse(buf[:n])
+	if err != nil {
+		t.Fatalf("parseResponse(_): %s", err)
+	}
+	if r.id != id {
+		t.Errorf("got r.id = %d, want = %d", r.id, id)
+	}
+	return r
+}
+
+func TestResolveCache(t *testing.T) {
+	clock := faketime.NewManualClock()
+	s := newStack(t, clock)
+	queries := newUpstream(t, s, Port+1)
+	newServer(t, s, Config{
+		Upstreams: []Upstream{{Addr: tcpip.FullAddress{Addr: upstreamAddr, Port: Port + 1}}},
+		CacheSize: 10,
+	})
+
+	const name = "www.example.com."
+	if r := lookup(t, s, 1, name); r.rcode != rcodeSuccess || r.minTTL != ttl {
+		t.Errorf("got (r.rcode, r.minTTL) = (%d, %d), want = (%d, %d)", r.rcode, r.minTTL, rcodeSuccess, ttl)
+	}
+
+	// The response is cached, with its TTL reduced.
+	clock.Advance(10 * time.Second)
+	if r := lookup(t, s, 2, strings.ToUpper(name)); r.rcode != rcodeSuccess || r.minTTL != ttl-10 {
+		t.Errorf("got (r.rcode, r.minTTL) = (%d, %d), want = (%d, %d)", r.rcode, r.minTTL, rcodeSuccess, ttl-10)
+	}
+	if got := atomic.LoadInt32(queries); got != 1 {
+		t.Errorf("got %d upstream queries, want = 1", got)
+	}
+
+	// The response expires with its TTL.
+	clock.Advance(ttl * time.Second)
+	if r := lookup(t, s, 3, name); r.minTTL != ttl {
+		t.Errorf("got r.minTTL = %d, want = %d", r.minTTL, ttl)
+	}
+	if got := atomic.LoadInt32(queries); got != 2 {
+		t.Errorf("got %d upstream queries, want = 2", got)
+	}
+}
+
+func TestConditionalForwarding(t *testing.T) {
+	s := newStack(t, nil)
+	defaultQueries := newUpstream(t, s, Port+1)
+	corpQueries := newUpstream(t, s, Port+2)
+	newServer(t, s, Config{
+		Upstreams: []Upstream{{Addr: tcpip.FullAddress{Addr: upstreamAddr, Port: Port + 1}}},
+		Forwards: []Forward{{
+			Domain:    "corp.example.com.",
+			Upstreams: []Upstream{{Addr: tcpip.FullAddress{Addr: upstreamAddr, Port: Port + 2}}},
+		}},
+	})
+
+	for i, name := range []string{"corp.example.com.", "www.corp.example.com.", "example.com.", "xcorp.example.com."} {
+		lookup(t, s, uint16(i), name)
+	}
+	if got := atomic.LoadInt32(corpQueries); got != 2 {
+		t.Errorf("got %d queries forwarded to the corp.example.com server, want = 2", got)
+	}
+	if got := atomic.LoadInt32(defaultQueries); got != 2 {
+		t.Errorf("got %d queries forwarded to the default server, want = 2", got)
+	}
+}
+
+func TestServerFailure(t *testing.T) {
+	s := newStack(t, nil)
+	newServer(t, s, Config{
+		// There is no server on the upstream port.
+		Upstreams: []Upstream{{Addr: tcpip.FullAddress{Addr: upstreamAddr, Port: Port + 1}}},
+		Timeout:   100 * time.Millisecond,
+	})
+
+	if r := lookup(t, s, 1, "www.example.com."); r.rcode != rcodeServerFailure {
+		t.Errorf("got r.rcode = %d, want = %d", r.rcode, rcodeServerFailure)
+	}
+}
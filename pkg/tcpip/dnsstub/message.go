@@ -0,0 +1,275 @@
+// Copyright 2021 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dnsstub
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+)
+
+const (
+	// headerSize is the size of the header of DNS messages.
+	headerSize = 12
+
+	// maxUDPSize is the maximum size of DNS messages sent over UDP to
+	// clients that don't advertise a larger size with EDNS.
+	maxUDPSize = 512
+
+	// maxNameSize is the maximum size of an encoded domain name.
+	maxNameSize = 255
+
+	// maxPointers is the maximum number of compression pointers followed
+	// when reading a domain name, to detect loops.
+	maxPointers = 64
+)
+
+// Header flags, as specified in RFC 1035 section 4.1.1.
+const (
+	flagResponse         = 1 << 15
+	flagTruncated        = 1 << 9
+	flagRecursionDesired = 1 << 8
+	flagRecursionAvail   = 1 << 7
+	opcodeMask           = 0xf << 11
+	rcodeMask            = 0xf
+)
+
+// Response codes, as specified in RFC 1035 section 4.1.1.
+const (
+	rcodeSuccess       = 0
+	rcodeServerFailure = 2
+	rcodeNameError     = 3
+)
+
+// typeOPT is the type of the EDNS OPT pseudo-record, whose TTL field holds
+// flags. See RFC 6891 section 6.1.2.
+const typeOPT = 41
+
+var errMalformed = errors.New("malformed DNS message")
+
+// question is the question of a DNS message, with its name in lower case.
+type question struct {
+	name   string
+	qtype  uint16
+	qclass uint16
+}
+
+// String implements fmt.Stringer.
+func (q question) String() string {
+	return fmt.Sprintf("%s (type %d, class %d)", q.name, q.qtype, q.qclass)
+}
+
+// query is a parsed DNS query.
+type query struct {
+	id       uint16
+	question question
+
+	// end is the offset of the end of the question section.
+	end int
+
+	// udpSize is the maximum size of the UDP responses the client accepts.
+	udpSize int
+}
+
+// response is a parsed DNS response.
+type response struct {
+	id        uint16
+	rcode     uint8
+	truncated bool
+	question  question
+
+	// ttlOffsets are the offsets of the TTLs of the resource records, other
+	// than OPT pseudo-records.
+	ttlOffsets []int
+
+	// minTTL is the smallest TTL of the resource records, or zero if there
+	// are none.
+	minTTL uint32
+}
+
+// cacheable returns whether the response can be cached.
+func (r *response) cacheable() bool {
+	return !r.truncated && r.minTTL > 0 && (r.rcode == rcodeSuccess || r.rcode == rcodeNameError)
+}
+
+// readName reads the domain name at off in msg and returns it in lower case,
+// with the offset following it.
+func readName(msg []byte, off int) (string, int, error) {
+	var (
+		name     []byte
+		next     = -1
+		pointers int
+	)
+	for {
+		if off >= len(msg) {
+			return "", 0, errMalformed
+		}
+		switch l := int(msg[off]); {
+		case l == 0:
+			if next < 0 {
+				next = off + 1
+			}
+			if len(name) == 0 {
+				return ".", next, nil
+			}
+			return string(bytes.ToLower(name)), next, nil
+		case l&0xc0 == 0xc0:
+			if off+2 > len(msg) {
+				return "", 0, errMalformed
+			}
+			if next < 0 {
+				next = off + 2
+			}
+			if pointers++; pointers > maxPointers {
+				return "", 0, errMalformed
+			}
+			off = int(binary.BigEndian.Uint16(msg[off:]) &^ 0xc000)
+		case l&0xc0 != 0:
+			// Extended label types are obsolete.
+			return "", 0, errMalformed
+		default:
+			off++
+			if off+l > len(msg) || len(name)+l+1 > maxNameSize {
+				return "", 0, errMalformed
+			}
+			name = append(name, msg[off:off+l]...)
+			name = append(name, '.')
+			off += l
+		}
+	}
+}
+
+// readQuestion reads the question at off in msg and returns it with the
+// offset following it.
+func readQuestion(msg []byte, off int) (question, int, error) {
+	name, off, err := readName(msg, off)
+	if err != nil {
+		return question{}, 0, err
+	}
+	if off+4 > len(msg) {
+		return question{}, 0, errMalformed
+	}
+	return question{
+		name:   name,
+		qtype:  binary.BigEndian.Uint16(msg[off:]),
+		qclass: binary.BigEndian.Uint16(msg[off+2:]),
+	}, off + 4, nil
+}
+
+// parseQuery parses a standard query with a single question.
+func parseQuery(msg []byte) (query, error) {
+	if len(msg) < headerSize {
+		return query{}, errMalformed
+	}
+	flags := binary.BigEndian.Uint16(msg[2:])
+	if flags&flagResponse != 0 || flags&opcodeMask != 0 || binary.BigEndian.Uint16(msg[4:]) != 1 {
+		return query{}, errMalformed
+	}
+	q, end, err := readQuestion(msg, headerSize)
+	if err != nil {
+		return query{}, err
+	}
+
+	udpSize := maxUDPSize
+	off := end
+	rrs := int(binary.BigEndian.Uint16(msg[6:])) + int(binary.BigEndian.Uint16(msg[8:])) + int(binary.BigEndian.Uint16(msg[10:]))
+	for i := 0; i < rrs; i++ {
+		if _, off, err = readName(msg, off); err != nil {
+			return query{}, err
+		}
+		if off+10 > len(msg) {
+			return query{}, errMalformed
+		}
+		if binary.BigEndian.Uint16(msg[off:]) == typeOPT {
+			// The class of OPT pseudo-records holds the UDP payload size
+			// of the requester.
+			if size := int(binary.BigEndian.Uint16(msg[off+2:])); size > udpSize {
+				udpSize = size
+			}
+		}
+		off += 10 + int(binary.BigEndian.Uint16(msg[off+8:]))
+		if off > len(msg) {
+			return query{}, errMalformed
+		}
+	}
+
+	return query{
+		id:       binary.BigEndian.Uint16(msg),
+		question: q,
+		end:      end,
+		udpSize:  udpSize,
+	}, nil
+}
+
+// parseResponse parses a response to a query with a single question.
+func parseResponse(msg []byte) (response, error) {
+	if len(msg) < headerSize {
+		return response{}, errMalformed
+	}
+	flags := binary.BigEndian.Uint16(msg[2:])
+	if flags&flagResponse == 0 || binary.BigEndian.Uint16(msg[4:]) != 1 {
+		return response{}, errMalformed
+	}
+	q, off, err := readQuestion(msg, headerSize)
+	if err != nil {
+		return response{}, err
+	}
+
+	r := response{
+		id:        binary.BigEndian.Uint16(msg),
+		rcode:     uint8(flags & rcodeMask),
+		truncated: flags&flagTruncated != 0,
+		question:  q,
+		minTTL:    math.MaxUint32,
+	}
+	rrs := int(binary.BigEndian.Uint16(msg[6:])) + int(binary.BigEndian.Uint16(msg[8:])) + int(binary.BigEndian.Uint16(msg[10:]))
+	for i := 0; i < rrs; i++ {
+		if _, off, err = readName(msg, off); err != nil {
+			return response{}, err
+		}
+		if off+10 > len(msg) {
+			return response{}, errMalformed
+		}
+		if binary.BigEndian.Uint16(msg[off:]) != typeOPT {
+			r.ttlOffsets = append(r.ttlOffsets, off+4)
+			if ttl := binary.BigEndian.Uint32(msg[off+4:]); ttl < r.minTTL {
+				r.minTTL = ttl
+			}
+		}
+		off += 10 + int(binary.BigEndian.Uint16(msg[off+8:]))
+		if off > len(msg) {
+			return response{}, errMalformed
+		}
+	}
+	if len(r.ttlOffsets) == 0 {
+		r.minTTL = 0
+	}
+	return r, nil
+}
+
+// emptyResponse returns a response to q, without any resource record, with
+// the response code and flags.
+func emptyResponse(msg []byte, q *query, rcode uint8, flags uint16) []byte {
+	resp := append([]byte(nil), msg[:q.end]...)
+	flags |= binary.BigEndian.Uint16(resp[2:])&flagRecursionDesired | flagResponse | flagRecursionAvail | uint16(rcode)
+	binary.BigEndian.PutUint16(resp[2:], flags)
+	// Only keep the question.
+	for i := 6; i < headerSize; i++ {
+		resp[i] = 0
+	}
+	return resp
+}
@@ -0,0 +1,111 @@
+// Copyright 2021 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package header
+
+import (
+	"encoding/binary"
+
+	"gvisor.dev/gvisor/pkg/tcpip"
+)
+
+const (
+	geneveVerOptLen = 0
+	geneveFlags     = 1
+	geneveProtocol  = 2
+	geneveVNI       = 4
+)
+
+const (
+	// geneveFlagOAM is the O bit, set on control messages.
+	geneveFlagOAM = 0x80
+
+	// geneveFlagCritical is the C bit, set when critical options are present.
+	geneveFlagCritical = 0x40
+)
+
+// GENEVEFields contains the fields of a GENEVE header. It is used to describe
+// the fields of a packet that needs to be encoded. Options are not supported.
+type GENEVEFields struct {
+	// OAM is the "O" bit of the GENEVE header.
+	OAM bool
+
+	// Protocol is the "protocol type" field of the GENEVE header, the
+	// ethertype of the payload.
+	Protocol tcpip.NetworkProtocolNumber
+
+	// VNI is the "virtual network identifier" field of the GENEVE header.
+	VNI uint32
+}
+
+// GENEVE represents a Generic Network Virtualization Encapsulation header
+// stored in a byte array, the fields are described in RFC 8926 section 3.4.
+type GENEVE []byte
+
+const (
+	// GENEVEMinimumSize is the size of a GENEVE header without options.
+	GENEVEMinimumSize = 8
+
+	// GENEVEPort is the UDP port assigned to GENEVE by IANA.
+	GENEVEPort = 6081
+
+	// GENEVEVersion is the only GENEVE version defined.
+	GENEVEVersion = 0
+
+	// GENEVETransparentEthernetBridging is the protocol type of Ethernet
+	// frames carried by GENEVE.
+	GENEVETransparentEthernetBridging tcpip.NetworkProtocolNumber = 0x6558
+)
+
+// Version returns the version of the GENEVE header.
+func (b GENEVE) Version() uint8 {
+	return b[geneveVerOptLen] >> 6
+}
+
+// HeaderLength returns the total length of the GENEVE header, including
+// options.
+func (b GENEVE) HeaderLength() int {
+	return GENEVEMinimumSize + 4*int(b[geneveVerOptLen]&0x3f)
+}
+
+// OAM returns true if the packet is a control message.
+func (b GENEVE) OAM() bool {
+	return b[geneveFlags]&geneveFlagOAM != 0
+}
+
+// Critical returns true if the header holds critical options.
+func (b GENEVE) Critical() bool {
+	return b[geneveFlags]&geneveFlagCritical != 0
+}
+
+// Protocol returns the protocol type of the payload.
+func (b GENEVE) Protocol() tcpip.NetworkProtocolNumber {
+	return tcpip.NetworkProtocolNumber(binary.BigEndian.Uint16(b[geneveProtocol:]))
+}
+
+// VNI returns the virtual network identifier.
+func (b GENEVE) VNI() uint32 {
+	return binary.BigEndian.Uint32(b[geneveVNI:]) >> 8
+}
+
+// Encode encodes all the fields of the GENEVE header.
+func (b GENEVE) Encode(i *GENEVEFields) {
+	b[geneveVerOptLen] = GENEVEVersion << 6
+	b[geneveFlags] = 0
+	if i.OAM {
+		b[geneveFlags] = geneveFlagOAM
+	}
+	binary.BigEndian.PutUint16(b[geneveProtocol:], uint16(i.Protocol))
+	binary.BigEndian.PutUint32(b[geneveVNI:], i.VNI<<8)
+}
@@ -0,0 +1,264 @@
+// Copyright 2021 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package header
+
+import (
+	"encoding/binary"
+	"fmt"
+	"time"
+
+	"gvisor.dev/gvisor/pkg/tcpip"
+)
+
+const (
+	// IGMPv3QueryMinimumSize is the minimum size of a valid IGMPv3 Membership
+	// Query message in bytes, as per RFC 3376 section 4.1.
+	IGMPv3QueryMinimumSize = 12
+
+	// IGMPv3ReportMinimumSize is the minimum size of a valid IGMPv3 Membership
+	// Report message in bytes, as per RFC 3376 section 4.2.
+	IGMPv3ReportMinimumSize = 8
+
+	// IGMPv3ReportGroupAddressRecordMinimumSize is the minimum size of a
+	// group record in an IGMPv3 Membership Report, as per RFC 3376 section
+	// 4.2.
+	IGMPv3ReportGroupAddressRecordMinimumSize = 8
+
+	// IGMPv3MembershipReport indicates that the message type is a Version 3
+	// Membership Report, as per RFC 3376 section 4.
+	IGMPv3MembershipReport IGMPType = 0x22
+
+	igmpv3QueryResvSQRVOffset          = 8
+	igmpv3QueryQRVMask                 = 0x7
+	igmpv3QueryQQICOffset              = 9
+	igmpv3QueryNumberOfSourcesOffset   = 10
+	igmpv3QuerySourcesOffset           = 12
+	igmpv3ReportNumberOfRecordsOffset  = 6
+	igmpv3ReportRecordsOffset          = 8
+	igmpv3RecordNumberOfSourcesOffset  = 2
+	igmpv3RecordGroupAddressOffset     = 4
+	igmpv3RecordSourcesOffset          = 8
+	igmpv3MaxRespCodeExponentialValue  = 128
+	igmpv3MaxRespCodeUnit              = time.Second / 10
+	igmpv3ReportRecordAuxDataLenOffset = 1
+)
+
+// IGMPv3Query is an IGMPv3 Membership Query message.
+//
+// As per RFC 3376 section 4.1, IGMPv3 Membership Queries have the following
+// format:
+//
+//    0                   1                   2                   3
+//    0 1 2 3 4 5 6 7 8 9 0 1 2 3 4 5 6 7 8 9 0 1 2 3 4 5 6 7 8 9 0 1
+//   +-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+//   |  Type = 0x11  | Max Resp Code |           Checksum            |
+//   +-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+//   |                         Group Address                         |
+//   +-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+//   | Resv  |S| QRV |     QQIC      |     Number of Sources (N)     |
+//   +-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+//   |                       Source Address [1]                      |
+//   +-                                                             -+
+//   .                               .                               .
+//   +-                                                             -+
+//   |                       Source Address [N]                      |
+//   +-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+type IGMPv3Query IGMP
+
+// MaximumResponseDelay returns the Maximum Response Delay encoded in the Max
+// Resp Code field.
+func (b IGMPv3Query) MaximumResponseDelay() time.Duration {
+	// As per RFC 3376 section 4.1.1,
+	//
+	//   If Max Resp Code < 128, Max Resp Time = Max Resp Code
+	//
+	//   If Max Resp Code >= 128, Max Resp Code represents a floating-point
+	//   value as follows:
+	//
+	//       0 1 2 3 4 5 6 7
+	//      +-+-+-+-+-+-+-+-+
+	//      |1| exp | mant  |
+	//      +-+-+-+-+-+-+-+-+
+	//
+	//   Max Resp Time = (mant | 0x10) << (exp + 3)
+	return igmpv3DecodeCode(b[igmpMaxRespTimeOffset]) * igmpv3MaxRespCodeUnit
+}
+
+// GroupAddress returns the group address.
+func (b IGMPv3Query) GroupAddress() tcpip.Address {
+	return IGMP(b).GroupAddress()
+}
+
+// QuerierRobustnessVariable returns the querier's robustness variable.
+func (b IGMPv3Query) QuerierRobustnessVariable() uint8 {
+	return b[igmpv3QueryResvSQRVOffset] & igmpv3QueryQRVMask
+}
+
+// QuerierQueryInterval returns the querier's query interval.
+func (b IGMPv3Query) QuerierQueryInterval() time.Duration {
+	// As per RFC 3376 section 4.1.7, the QQIC field is encoded the same way
+	// as the Max Resp Code, in units of seconds.
+	return igmpv3DecodeCode(b[igmpv3QueryQQICOffset]) * time.Second
+}
+
+// Sources returns the source addresses of a Group-and-Source-Specific Query,
+// or false if the message is too short to hold them.
+func (b IGMPv3Query) Sources() ([]tcpip.Address, bool) {
+	n := int(binary.BigEndian.Uint16(b[igmpv3QueryNumberOfSourcesOffset:]))
+	srcs := b[igmpv3QuerySourcesOffset:]
+	if len(srcs) < n*IPv4AddressSize {
+		return nil, false
+	}
+	sources := make([]tcpip.Address, 0, n)
+	for i := 0; i < n; i++ {
+		sources = append(sources, tcpip.Address(srcs[i*IPv4AddressSize:][:IPv4AddressSize]))
+	}
+	return sources, true
+}
+
+func igmpv3DecodeCode(code uint8) time.Duration {
+	if code < igmpv3MaxRespCodeExponentialValue {
+		return time.Duration(code)
+	}
+	exp := (code >> 4) & 0x7
+	mant := code & 0xf
+	return time.Duration(mant|0x10) << (exp + 3)
+}
+
+// IGMPv3ReportRecordType is the type of a group record in an IGMPv3 Membership
+// Report, as per RFC 3376 section 4.2.12.
+type IGMPv3ReportRecordType uint8
+
+// IGMPv3 group record types.
+const (
+	IGMPv3ReportRecordModeIsInclude       IGMPv3ReportRecordType = 1
+	IGMPv3ReportRecordModeIsExclude       IGMPv3ReportRecordType = 2
+	IGMPv3ReportRecordChangeToIncludeMode IGMPv3ReportRecordType = 3
+	IGMPv3ReportRecordChangeToExcludeMode IGMPv3ReportRecordType = 4
+	IGMPv3ReportRecordAllowNewSources     IGMPv3ReportRecordType = 5
+	IGMPv3ReportRecordBlockOldSources     IGMPv3ReportRecordType = 6
+)
+
+// IGMPv3ReportGroupAddressRecord is a group record in an IGMPv3 Membership
+// Report.
+type IGMPv3ReportGroupAddressRecord struct {
+	RecordType   IGMPv3ReportRecordType
+	GroupAddress tcpip.Address
+	Sources      []tcpip.Address
+}
+
+func (r *IGMPv3ReportGroupAddressRecord) length() int {
+	return IGMPv3ReportGroupAddressRecordMinimumSize + len(r.Sources)*IPv4AddressSize
+}
+
+// IGMPv3ReportSerializer serializes an IGMPv3 Membership Report.
+//
+// As per RFC 3376 section 4.2, IGMPv3 Membership Reports have the following
+// format:
+//
+//    0                   1                   2                   3
+//    0 1 2 3 4 5 6 7 8 9 0 1 2 3 4 5 6 7 8 9 0 1 2 3 4 5 6 7 8 9 0 1
+//   +-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+//   |  Type = 0x22  |    Reserved   |           Checksum            |
+//   +-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+//   |           Reserved            |  Number of Group Records (M)  |
+//   +-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+//   .                                                               .
+//   .                        Group Record [1..M]                    .
+//   .                                                               .
+//   +-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+//
+// where each group record has the following format:
+//
+//   +-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+//   |  Record Type  |  Aux Data Len |     Number of Sources (N)     |
+//   +-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+//   |                       Multicast Address                       |
+//   +-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+//   |                  Source Address [1..N]                        |
+//   +-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+type IGMPv3ReportSerializer struct {
+	Records []IGMPv3ReportGroupAddressRecord
+}
+
+// Length returns the number of bytes this serializer would occupy.
+func (s *IGMPv3ReportSerializer) Length() int {
+	l := IGMPv3ReportMinimumSize
+	for i := range s.Records {
+		l += s.Records[i].length()
+	}
+	return l
+}
+
+// SerializeInto serializes the report into b, which must be at least
+// s.Length() bytes long. The checksum is left zero.
+func (s *IGMPv3ReportSerializer) SerializeInto(b []byte) {
+	b = b[:s.Length()]
+	for i := range b {
+		b[i] = 0
+	}
+	b[igmpTypeOffset] = byte(IGMPv3MembershipReport)
+	binary.BigEndian.PutUint16(b[igmpv3ReportNumberOfRecordsOffset:], uint16(len(s.Records)))
+	rb := b[igmpv3ReportRecordsOffset:]
+	for i := range s.Records {
+		r := &s.Records[i]
+		rb[0] = byte(r.RecordType)
+		rb[igmpv3ReportRecordAuxDataLenOffset] = 0
+		binary.BigEndian.PutUint16(rb[igmpv3RecordNumberOfSourcesOffset:], uint16(len(r.Sources)))
+		if n := copy(rb[igmpv3RecordGroupAddressOffset:], r.GroupAddress); n != IPv4AddressSize {
+			panic(fmt.Sprintf("copied %d bytes, expected %d", n, IPv4AddressSize))
+		}
+		for j, src := range r.Sources {
+			if n := copy(rb[igmpv3RecordSourcesOffset+j*IPv4AddressSize:], src); n != IPv4AddressSize {
+				panic(fmt.Sprintf("copied %d bytes, expected %d", n, IPv4AddressSize))
+			}
+		}
+		rb = rb[r.length():]
+	}
+}
+
+// IGMPv3Report is an IGMPv3 Membership Report message.
+type IGMPv3Report IGMP
+
+// GroupAddressRecords returns the group records held in the report, or false
+// if the report is malformed.
+func (b IGMPv3Report) GroupAddressRecords() ([]IGMPv3ReportGroupAddressRecord, bool) {
+	if len(b) < IGMPv3ReportMinimumSize {
+		return nil, false
+	}
+	n := int(binary.BigEndian.Uint16(b[igmpv3ReportNumberOfRecordsOffset:]))
+	rb := []byte(b[igmpv3ReportRecordsOffset:])
+	records := make([]IGMPv3ReportGroupAddressRecord, 0, n)
+	for i := 0; i < n; i++ {
+		if len(rb) < IGMPv3ReportGroupAddressRecordMinimumSize {
+			return nil, false
+		}
+		numSources := int(binary.BigEndian.Uint16(rb[igmpv3RecordNumberOfSourcesOffset:]))
+		l := IGMPv3ReportGroupAddressRecordMinimumSize + numSources*IPv4AddressSize + int(rb[igmpv3ReportRecordAuxDataLenOffset])*4
+		if len(rb) < l {
+			return nil, false
+		}
+		r := IGMPv3ReportGroupAddressRecord{
+			RecordType:   IGMPv3ReportRecordType(rb[0]),
+			GroupAddress: tcpip.Address(rb[igmpv3RecordGroupAddressOffset:][:IPv4AddressSize]),
+		}
+		for j := 0; j < numSources; j++ {
+			r.Sources = append(r.Sources, tcpip.Address(rb[igmpv3RecordSourcesOffset+j*IPv4AddressSize:][:IPv4AddressSize]))
+		}
+		records = append(records, r)
+		rb = rb[l:]
+	}
+	return records, true
+}
@@ -0,0 +1,121 @@
+// Copyright 2021 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package header_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"gvisor.dev/gvisor/pkg/tcpip"
+	"gvisor.dev/gvisor/pkg/tcpip/header"
+)
+
+func TestIGMPv3Query(t *testing.T) {
+	b := []byte{
+		0x11,       // IGMP Type, Membership Query
+		0x8A,       // Max Resp Code, exp = 0, mant = 0xA
+		0x00, 0x00, // Checksum
+		0xE0, 0x01, 0x02, 0x03, // Group Address
+		0x0A,       // Resv, S = 1, QRV = 2
+		0x7D,       // QQIC
+		0x00, 0x02, // Number of Sources
+		0x0A, 0x00, 0x00, 0x01, // Source Address [1]
+		0x0A, 0x00, 0x00, 0x02, // Source Address [2]
+	}
+
+	query := header.IGMPv3Query(b)
+
+	if got, want := query.MaximumResponseDelay(), 0x1A*8*time.Second/10; got != want {
+		t.Errorf("got query.MaximumResponseDelay() = %s, want = %s", got, want)
+	}
+
+	if got, want := query.GroupAddress(), tcpip.Address("\xe0\x01\x02\x03"); got != want {
+		t.Errorf("got query.GroupAddress() = %s, want = %s", got, want)
+	}
+
+	if got, want := query.QuerierRobustnessVariable(), uint8(2); got != want {
+		t.Errorf("got query.QuerierRobustnessVariable() = %d, want = %d", got, want)
+	}
+
+	if got, want := query.QuerierQueryInterval(), 125*time.Second; got != want {
+		t.Errorf("got query.QuerierQueryInterval() = %s, want = %s", got, want)
+	}
+
+	sources, ok := query.Sources()
+	if !ok {
+		t.Fatal("got query.Sources() = (_, false), want = (_, true)")
+	}
+	if diff := cmp.Diff([]tcpip.Address{"\x0a\x00\x00\x01", "\x0a\x00\x00\x02"}, sources); diff != "" {
+		t.Errorf("query.Sources() mismatch (-want +got):\n%s", diff)
+	}
+
+	if _, ok := header.IGMPv3Query(b[:len(b)-1]).Sources(); ok {
+		t.Error("got truncated query.Sources() = (_, true), want = (_, false)")
+	}
+}
+
+func TestIGMPv3Report(t *testing.T) {
+	records := []header.IGMPv3ReportGroupAddressRecord{
+		{
+			RecordType:   header.IGMPv3ReportRecordChangeToIncludeMode,
+			GroupAddress: "\xe0\x01\x02\x03",
+			Sources:      []tcpip.Address{"\x0a\x00\x00\x01", "\x0a\x00\x00\x02"},
+		},
+		{
+			RecordType:   header.IGMPv3ReportRecordModeIsExclude,
+			GroupAddress: "\xe0\x01\x02\x04",
+		},
+	}
+	s := header.IGMPv3ReportSerializer{Records: records}
+
+	b := make([]byte, s.Length())
+	s.SerializeInto(b)
+
+	want := []byte{
+		0x22,       // IGMP Type, Version 3 Membership Report
+		0x00,       // Reserved
+		0x00, 0x00, // Checksum
+		0x00, 0x00, // Reserved
+		0x00, 0x02, // Number of Group Records
+
+		0x03,       // Record Type, CHANGE_TO_INCLUDE_MODE
+		0x00,       // Aux Data Len
+		0x00, 0x02, // Number of Sources
+		0xE0, 0x01, 0x02, 0x03, // Multicast Address
+		0x0A, 0x00, 0x00, 0x01, // Source Address [1]
+		0x0A, 0x00, 0x00, 0x02, // Source Address [2]
+
+		0x02,       // Record Type, MODE_IS_EXCLUDE
+		0x00,       // Aux Data Len
+		0x00, 0x00, // Number of Sources
+		0xE0, 0x01, 0x02, 0x04, // Multicast Address
+	}
+	if diff := cmp.Diff(want, b); diff != "" {
+		t.Fatalf("serialized report mismatch (-want +got):\n%s", diff)
+	}
+
+	got, ok := header.IGMPv3Report(b).GroupAddressRecords()
+	if !ok {
+		t.Fatal("got GroupAddressRecords() = (_, false), want = (_, true)")
+	}
+	if diff := cmp.Diff(records, got); diff != "" {
+		t.Errorf("GroupAddressRecords() mismatch (-want +got):\n%s", diff)
+	}
+
+	if _, ok := header.IGMPv3Report(b[:len(b)-1]).GroupAddressRecords(); ok {
+		t.Error("got truncated GroupAddressRecords() = (_, true), want = (_, false)")
+	}
+}
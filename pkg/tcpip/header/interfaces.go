@@ -28,6 +28,26 @@ const (
 	MaxIPPacketSize = 0xffff + 2*IPv6MinimumSize
 )
 
+// ECN codepoints carried in the two least significant bits of the IPv4 "type
+// of service" and IPv6 "traffic class" fields, as defined in RFC 3168
+// section 5.
+const (
+	// ECNMask is the mask of the ECN field.
+	ECNMask = 0x3
+
+	// ECNNotECT is the Not ECN-Capable Transport codepoint.
+	ECNNotECT = 0x0
+
+	// ECNECT1 is the ECN-Capable Transport codepoint ECT(1).
+	ECNECT1 = 0x1
+
+	// ECNECT0 is the ECN-Capable Transport codepoint ECT(0).
+	ECNECT0 = 0x2
+
+	// ECNCE is the Congestion Experienced codepoint.
+	ECNCE = 0x3
+)
+
 // Transport offers generic methods to query and/or update the fields of the
 // header of a transport protocol buffer.
 type Transport interface {
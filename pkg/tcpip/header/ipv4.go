@@ -159,6 +159,10 @@ const (
 	// IPv4AllRoutersGroup is a multicast address for all routers.
 	IPv4AllRoutersGroup tcpip.Address = "\xe0\x00\x00\x02"
 
+	// IGMPv3RoutersAddress is the address IGMPv3 reports are sent to, as per
+	// RFC 3376 section 4.2.14.
+	IGMPv3RoutersAddress tcpip.Address = "\xe0\x00\x00\x16"
+
 	// IPv4MinimumProcessableDatagramSize is the minimum size of an IP
 	// packet that every IPv4 capable host must be able to
 	// process/reassemble.
@@ -433,6 +437,16 @@ func IsV4MulticastAddress(addr tcpip.Address) bool {
 	return (addr[0] & 0xf0) == 0xe0
 }
 
+// IsV4LinkLocalMulticastAddress determines if the provided address is an IPv4
+// link-local multicast address (range 224.0.0.0 to 224.0.0.255), as per RFC
+// 5771 section 4. Packets sent to these addresses are not forwarded.
+func IsV4LinkLocalMulticastAddress(addr tcpip.Address) bool {
+	if len(addr) != IPv4AddressSize {
+		return false
+	}
+	return addr[0] == 224 && addr[1] == 0 && addr[2] == 0
+}
+
 // IsV4LoopbackAddress determines if the provided address is an IPv4 loopback
 // address (belongs to 127.0.0.0/8 subnet). See RFC 1122 section 3.2.1.3.
 func IsV4LoopbackAddress(addr tcpip.Address) bool {
@@ -105,6 +105,12 @@ const (
 	// The address is ff02::2.
 	IPv6AllRoutersMulticastAddress tcpip.Address = "\xff\x02\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x02"
 
+	// MLDv2RoutersAddress is the link-local multicast group that MLDv2
+	// reports are sent to, as per RFC 3810 section 5.2.14.
+	//
+	// The address is ff02::16.
+	MLDv2RoutersAddress tcpip.Address = "\xff\x02\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x16"
+
 	// IPv6MinimumMTU is the minimum MTU required by IPv6, per RFC 8200,
 	// section 5:
 	//   IPv6 requires that every link in the Internet have an MTU of 1280 octets
@@ -402,6 +408,29 @@ func IsV6LinkLocalMulticastAddress(addr tcpip.Address) bool {
 	return IsV6MulticastAddress(addr) && addr[ipv6MulticastAddressScopeByteIdx]&ipv6MulticastAddressScopeMask == ipv6LinkLocalMulticastScope
 }
 
+// IPv6MulticastScope is the scope of a multicast IPv6 address, as per RFC 4291
+// section 2.7.
+type IPv6MulticastScope uint8
+
+// The IPv6 multicast scopes, as per RFC 4291 section 2.7 and RFC 7346 section
+// 2.
+const (
+	IPv6InterfaceLocalMulticastScope    IPv6MulticastScope = 0x1
+	IPv6LinkLocalMulticastScope         IPv6MulticastScope = 0x2
+	IPv6RealmLocalMulticastScope        IPv6MulticastScope = 0x3
+	IPv6AdminLocalMulticastScope        IPv6MulticastScope = 0x4
+	IPv6SiteLocalMulticastScope         IPv6MulticastScope = 0x5
+	IPv6OrganizationLocalMulticastScope IPv6MulticastScope = 0x8
+	IPv6GlobalMulticastScope            IPv6MulticastScope = 0xE
+)
+
+// V6MulticastScope returns the scope of the provided multicast IPv6 address.
+//
+// The address must be a multicast IPv6 address.
+func V6MulticastScope(addr tcpip.Address) IPv6MulticastScope {
+	return IPv6MulticastScope(addr[ipv6MulticastAddressScopeByteIdx] & ipv6MulticastAddressScopeMask)
+}
+
 // AppendOpaqueInterfaceIdentifier appends a 64 bit opaque interface identifier
 // (IID) to buf as outlined by RFC 7217 and returns the extended buffer.
 //
@@ -0,0 +1,262 @@
+// Copyright 2021 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package header
+
+import (
+	"encoding/binary"
+	"fmt"
+	"time"
+
+	"gvisor.dev/gvisor/pkg/tcpip"
+)
+
+const (
+	// MLDv2QueryMinimumSize is the minimum size for an MLDv2 Query message,
+	// as per RFC 3810 section 5.1.
+	MLDv2QueryMinimumSize = 24
+
+	// MLDv2ReportMinimumSize is the minimum size for an MLDv2 Report message,
+	// as per RFC 3810 section 5.2.
+	MLDv2ReportMinimumSize = 4
+
+	// MLDv2ReportMulticastAddressRecordMinimumSize is the minimum size of a
+	// multicast address record in an MLDv2 Report, as per RFC 3810 section
+	// 5.2.
+	MLDv2ReportMulticastAddressRecordMinimumSize = 20
+
+	mldv2QueryResvSQRVOffset              = 20
+	mldv2QueryQRVMask                     = 0x7
+	mldv2QueryQQICOffset                  = 21
+	mldv2QueryNumberOfSourcesOffset       = 22
+	mldv2QuerySourcesOffset               = 24
+	mldv2MaxRespCodeExponentialValue      = 32768
+	mldv2ReportNumberOfRecordsOffset      = 2
+	mldv2ReportRecordsOffset              = 4
+	mldv2ReportRecordAuxDataLenOffset     = 1
+	mldv2ReportRecordNumberOfSourceOffset = 2
+	mldv2ReportRecordMulticastAddrOffset  = 4
+	mldv2ReportRecordSourcesOffset        = 20
+)
+
+// MLDv2Query is an MLDv2 Query message in an ICMPv6 packet.
+//
+// MLDv2Query will only contain the body of an ICMPv6 packet.
+//
+// As per RFC 3810 section 5.1, MLDv2 Queries have the following format
+// (MLDv2Query only holds the bytes after the first four bytes in the diagram
+// below):
+//
+//    0                   1                   2                   3
+//    0 1 2 3 4 5 6 7 8 9 0 1 2 3 4 5 6 7 8 9 0 1 2 3 4 5 6 7 8 9 0 1
+//   +-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+//   |  Type = 130   |      Code     |           Checksum            |
+//   +-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+//   |    Maximum Response Code      |           Reserved            |
+//   +-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+//   |                                                               |
+//   *                       Multicast Address                       *
+//   |                                                               |
+//   +-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+//   | Resv  |S| QRV |     QQIC      |     Number of Sources (N)     |
+//   +-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+//   |                                                               |
+//   *                     Source Address [1..N]                     *
+//   |                                                               |
+//   +-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+type MLDv2Query MLD
+
+// MaximumResponseDelay returns the Maximum Response Delay encoded in the
+// Maximum Response Code field.
+func (m MLDv2Query) MaximumResponseDelay() time.Duration {
+	// As per RFC 3810 section 5.1.3,
+	//
+	//   If Maximum Response Code < 32768,
+	//      Maximum Response Delay = Maximum Response Code
+	//
+	//   If Maximum Response Code >=32768, Maximum Response Code represents a
+	//   floating-point value as follows:
+	//
+	//       0 1 2 3 4 5 6 7 8 9 A B C D E F
+	//      +-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+	//      |1| exp |          mant         |
+	//      +-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+	//
+	//   Maximum Response Delay = (mant | 0x1000) << (exp+3)
+	code := binary.BigEndian.Uint16(m[mldMaximumResponseDelayOffset:])
+	if code < mldv2MaxRespCodeExponentialValue {
+		return time.Duration(code) * time.Millisecond
+	}
+	exp := (code >> 12) & 0x7
+	mant := code & 0xfff
+	return (time.Duration(mant|0x1000) << (exp + 3)) * time.Millisecond
+}
+
+// MulticastAddress returns the Multicast Address.
+func (m MLDv2Query) MulticastAddress() tcpip.Address {
+	return MLD(m).MulticastAddress()
+}
+
+// QuerierRobustnessVariable returns the querier's robustness variable.
+func (m MLDv2Query) QuerierRobustnessVariable() uint8 {
+	return m[mldv2QueryResvSQRVOffset] & mldv2QueryQRVMask
+}
+
+// QuerierQueryInterval returns the querier's query interval.
+func (m MLDv2Query) QuerierQueryInterval() time.Duration {
+	// As per RFC 3810 section 5.1.9, the QQIC field is encoded the same way
+	// as the IGMPv3 QQIC field, in units of seconds.
+	return igmpv3DecodeCode(m[mldv2QueryQQICOffset]) * time.Second
+}
+
+// Sources returns the source addresses of a Multicast Address and Source
+// Specific Query, or false if the message is too short to hold them.
+func (m MLDv2Query) Sources() ([]tcpip.Address, bool) {
+	n := int(binary.BigEndian.Uint16(m[mldv2QueryNumberOfSourcesOffset:]))
+	srcs := m[mldv2QuerySourcesOffset:]
+	if len(srcs) < n*IPv6AddressSize {
+		return nil, false
+	}
+	sources := make([]tcpip.Address, 0, n)
+	for i := 0; i < n; i++ {
+		sources = append(sources, tcpip.Address(srcs[i*IPv6AddressSize:][:IPv6AddressSize]))
+	}
+	return sources, true
+}
+
+// MLDv2ReportRecordType is the type of a multicast address record in an MLDv2
+// Report, as per RFC 3810 section 5.2.12.
+type MLDv2ReportRecordType uint8
+
+// MLDv2 multicast address record types.
+const (
+	MLDv2ReportRecordModeIsInclude       MLDv2ReportRecordType = 1
+	MLDv2ReportRecordModeIsExclude       MLDv2ReportRecordType = 2
+	MLDv2ReportRecordChangeToIncludeMode MLDv2ReportRecordType = 3
+	MLDv2ReportRecordChangeToExcludeMode MLDv2ReportRecordType = 4
+	MLDv2ReportRecordAllowNewSources     MLDv2ReportRecordType = 5
+	MLDv2ReportRecordBlockOldSources     MLDv2ReportRecordType = 6
+)
+
+// MLDv2ReportMulticastAddressRecord is a multicast address record in an MLDv2
+// Report.
+type MLDv2ReportMulticastAddressRecord struct {
+	RecordType       MLDv2ReportRecordType
+	MulticastAddress tcpip.Address
+	Sources          []tcpip.Address
+}
+
+func (r *MLDv2ReportMulticastAddressRecord) length() int {
+	return MLDv2ReportMulticastAddressRecordMinimumSize + len(r.Sources)*IPv6AddressSize
+}
+
+// MLDv2ReportSerializer serializes the body of an MLDv2 Report.
+//
+// As per RFC 3810 section 5.2, MLDv2 Reports have the following format (the
+// serializer only produces the bytes after the first four bytes in the
+// diagram below):
+//
+//    0                   1                   2                   3
+//    0 1 2 3 4 5 6 7 8 9 0 1 2 3 4 5 6 7 8 9 0 1 2 3 4 5 6 7 8 9 0 1
+//   +-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+//   |  Type = 143   |    Reserved   |           Checksum            |
+//   +-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+//   |           Reserved            |Nr of Mcast Address Records (M)|
+//   +-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+//   .                                                               .
+//   .                  Multicast Address Record [1..M]              .
+//   .                                                               .
+//   +-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+//
+// where each multicast address record has the following format:
+//
+//   +-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+//   |  Record Type  |  Aux Data Len |     Number of Sources (N)     |
+//   +-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+//   *                       Multicast Address                       *
+//   +-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+//   *                     Source Address [1..N]                     *
+//   +-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+type MLDv2ReportSerializer struct {
+	Records []MLDv2ReportMulticastAddressRecord
+}
+
+// Length returns the number of bytes this serializer would occupy.
+func (s *MLDv2ReportSerializer) Length() int {
+	l := MLDv2ReportMinimumSize
+	for i := range s.Records {
+		l += s.Records[i].length()
+	}
+	return l
+}
+
+// SerializeInto serializes the report into b, which must be at least
+// s.Length() bytes long.
+func (s *MLDv2ReportSerializer) SerializeInto(b []byte) {
+	b = b[:s.Length()]
+	for i := range b {
+		b[i] = 0
+	}
+	binary.BigEndian.PutUint16(b[mldv2ReportNumberOfRecordsOffset:], uint16(len(s.Records)))
+	rb := b[mldv2ReportRecordsOffset:]
+	for i := range s.Records {
+		r := &s.Records[i]
+		rb[0] = byte(r.RecordType)
+		rb[mldv2ReportRecordAuxDataLenOffset] = 0
+		binary.BigEndian.PutUint16(rb[mldv2ReportRecordNumberOfSourceOffset:], uint16(len(r.Sources)))
+		if n := copy(rb[mldv2ReportRecordMulticastAddrOffset:], r.MulticastAddress); n != IPv6AddressSize {
+			panic(fmt.Sprintf("copied %d bytes, expected %d", n, IPv6AddressSize))
+		}
+		for j, src := range r.Sources {
+			if n := copy(rb[mldv2ReportRecordSourcesOffset+j*IPv6AddressSize:], src); n != IPv6AddressSize {
+				panic(fmt.Sprintf("copied %d bytes, expected %d", n, IPv6AddressSize))
+			}
+		}
+		rb = rb[r.length():]
+	}
+}
+
+// MLDv2Report is the body of an MLDv2 Report message in an ICMPv6 packet.
+type MLDv2Report []byte
+
+// MulticastAddressRecords returns the multicast address records held in the
+// report, or false if the report is malformed.
+func (m MLDv2Report) MulticastAddressRecords() ([]MLDv2ReportMulticastAddressRecord, bool) {
+	if len(m) < MLDv2ReportMinimumSize {
+		return nil, false
+	}
+	n := int(binary.BigEndian.Uint16(m[mldv2ReportNumberOfRecordsOffset:]))
+	rb := []byte(m[mldv2ReportRecordsOffset:])
+	records := make([]MLDv2ReportMulticastAddressRecord, 0, n)
+	for i := 0; i < n; i++ {
+		if len(rb) < MLDv2ReportMulticastAddressRecordMinimumSize {
+			return nil, false
+		}
+		numSources := int(binary.BigEndian.Uint16(rb[mldv2ReportRecordNumberOfSourceOffset:]))
+		l := MLDv2ReportMulticastAddressRecordMinimumSize + numSources*IPv6AddressSize + int(rb[mldv2ReportRecordAuxDataLenOffset])*4
+		if len(rb) < l {
+			return nil, false
+		}
+		r := MLDv2ReportMulticastAddressRecord{
+			RecordType:       MLDv2ReportRecordType(rb[0]),
+			MulticastAddress: tcpip.Address(rb[mldv2ReportRecordMulticastAddrOffset:][:IPv6AddressSize]),
+		}
+		for j := 0; j < numSources; j++ {
+			r.Sources = append(r.Sources, tcpip.Address(rb[mldv2ReportRecordSourcesOffset+j*IPv6AddressSize:][:IPv6AddressSize]))
+		}
+		records = append(records, r)
+		rb = rb[l:]
+	}
+	return records, true
+}
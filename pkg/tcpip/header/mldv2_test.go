@@ -0,0 +1,130 @@
+// Copyright 2021 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package header
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"gvisor.dev/gvisor/pkg/tcpip"
+)
+
+func TestMLDv2Query(t *testing.T) {
+	b := []byte{
+		// Maximum Response Code, exp = 1, mant = 0x034
+		0x90, 0x34,
+
+		// Reserved
+		0, 0,
+
+		// Multicast Address
+		0xff, 2, 3, 4, 5, 6, 7, 8, 9, 0, 1, 2, 3, 4, 5, 6,
+
+		// Resv, S = 0, QRV = 3
+		0x03,
+
+		// QQIC, exp = 1, mant = 0
+		0x90,
+
+		// Number of Sources
+		0, 1,
+
+		// Source Address [1]
+		1, 2, 3, 4, 5, 6, 7, 8, 9, 0, 1, 2, 3, 4, 5, 6,
+	}
+
+	query := MLDv2Query(b)
+
+	if got, want := query.MaximumResponseDelay(), time.Duration(0x1034<<4)*time.Millisecond; got != want {
+		t.Errorf("got query.MaximumResponseDelay() = %s, want = %s", got, want)
+	}
+
+	if got, want := query.MulticastAddress(), tcpip.Address([]byte{0xff, 2, 3, 4, 5, 6, 7, 8, 9, 0, 1, 2, 3, 4, 5, 6}); got != want {
+		t.Errorf("got query.MulticastAddress() = %s, want = %s", got, want)
+	}
+
+	if got, want := query.QuerierRobustnessVariable(), uint8(3); got != want {
+		t.Errorf("got query.QuerierRobustnessVariable() = %d, want = %d", got, want)
+	}
+
+	if got, want := query.QuerierQueryInterval(), time.Duration(0x10<<4)*time.Second; got != want {
+		t.Errorf("got query.QuerierQueryInterval() = %s, want = %s", got, want)
+	}
+
+	sources, ok := query.Sources()
+	if !ok {
+		t.Fatal("got query.Sources() = (_, false), want = (_, true)")
+	}
+	if diff := cmp.Diff([]tcpip.Address{tcpip.Address([]byte{1, 2, 3, 4, 5, 6, 7, 8, 9, 0, 1, 2, 3, 4, 5, 6})}, sources); diff != "" {
+		t.Errorf("query.Sources() mismatch (-want +got):\n%s", diff)
+	}
+
+	if _, ok := MLDv2Query(b[:len(b)-1]).Sources(); ok {
+		t.Error("got truncated query.Sources() = (_, true), want = (_, false)")
+	}
+}
+
+func TestMLDv2Report(t *testing.T) {
+	records := []MLDv2ReportMulticastAddressRecord{
+		{
+			RecordType:       MLDv2ReportRecordAllowNewSources,
+			MulticastAddress: tcpip.Address([]byte{0xff, 2, 3, 4, 5, 6, 7, 8, 9, 0, 1, 2, 3, 4, 5, 6}),
+			Sources:          []tcpip.Address{tcpip.Address([]byte{1, 2, 3, 4, 5, 6, 7, 8, 9, 0, 1, 2, 3, 4, 5, 6})},
+		},
+	}
+	s := MLDv2ReportSerializer{Records: records}
+
+	b := make([]byte, s.Length())
+	s.SerializeInto(b)
+
+	want := []byte{
+		// Reserved
+		0, 0,
+
+		// Number of Multicast Address Records
+		0, 1,
+
+		// Record Type, ALLOW_NEW_SOURCES
+		5,
+
+		// Aux Data Len
+		0,
+
+		// Number of Sources
+		0, 1,
+
+		// Multicast Address
+		0xff, 2, 3, 4, 5, 6, 7, 8, 9, 0, 1, 2, 3, 4, 5, 6,
+
+		// Source Address [1]
+		1, 2, 3, 4, 5, 6, 7, 8, 9, 0, 1, 2, 3, 4, 5, 6,
+	}
+	if diff := cmp.Diff(want, b); diff != "" {
+		t.Fatalf("serialized report mismatch (-want +got):\n%s", diff)
+	}
+
+	got, ok := MLDv2Report(b).MulticastAddressRecords()
+	if !ok {
+		t.Fatal("got MulticastAddressRecords() = (_, false), want = (_, true)")
+	}
+	if diff := cmp.Diff(records, got); diff != "" {
+		t.Errorf("MulticastAddressRecords() mismatch (-want +got):\n%s", diff)
+	}
+
+	if _, ok := MLDv2Report(b[:len(b)-1]).MulticastAddressRecords(); ok {
+		t.Error("got truncated MulticastAddressRecords() = (_, true), want = (_, false)")
+	}
+}
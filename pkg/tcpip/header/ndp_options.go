@@ -49,6 +49,10 @@ const (
 	// NDPDNSSearchListOptionType is the type of the DNS Search List option,
 	// as per RFC 8106 section 5.2.
 	NDPDNSSearchListOptionType = 31
+
+	// NDPPref64OptionType is the type of the PREF64 option, as per RFC 8781
+	// section 4.
+	NDPPref64OptionType NDPOptionIdentifier = 38
 )
 
 const (
@@ -132,6 +136,35 @@ const (
 	// section 5.3.1.
 	minNDPDNSSearchListBodySize = 14
 
+	// ndpPref64Length is the expected length, in bytes, of the body of an
+	// NDP PREF64 option, as per RFC 8781 section 4 which specifies that the
+	// Length field is 2.
+	ndpPref64Length = 14
+
+	// ndpPref64LifetimeOffset is the start of the 2-byte Scaled Lifetime and
+	// PLC fields within an NDPPref64.
+	ndpPref64LifetimeOffset = 0
+
+	// ndpPref64PLCMask is the mask of the Prefix Length Code field within
+	// the 2-byte Scaled Lifetime and PLC fields of an NDPPref64.
+	ndpPref64PLCMask = 0x7
+
+	// ndpPref64LifetimeShift is the shift of the Scaled Lifetime field
+	// within the 2-byte Scaled Lifetime and PLC fields of an NDPPref64.
+	ndpPref64LifetimeShift = 3
+
+	// ndpPref64LifetimeUnit is the unit of the Scaled Lifetime field of an
+	// NDPPref64, as per RFC 8781 section 4.
+	ndpPref64LifetimeUnit = 8 * time.Second
+
+	// ndpPref64PrefixOffset is the start of the highest 96 bits of the
+	// prefix within an NDPPref64.
+	ndpPref64PrefixOffset = 2
+
+	// ndpPref64PrefixSize is the size of the prefix bits carried in an
+	// NDPPref64.
+	ndpPref64PrefixSize = 12
+
 	// maxDomainNameLabelLength is the maximum length of a domain name
 	// label, as per RFC 1035 section 3.1.
 	maxDomainNameLabelLength = 63
@@ -257,6 +290,15 @@ func (i *NDPOptionIterator) Next() (NDPOption, bool, error) {
 
 			return opt, false, nil
 
+		case NDPPref64OptionType:
+			// Make sure the length of a PREF64 option body is
+			// ndpPref64Length, as per RFC 8781 section 4.
+			if numBodyBytes != ndpPref64Length {
+				return nil, true, fmt.Errorf("got %d bytes for NDP PREF64 option's body, expected %d bytes: %w", numBodyBytes, ndpPref64Length, ErrNDPOptMalformedBody)
+			}
+
+			return NDPPref64(body), false, nil
+
 		default:
 			// We do not yet recognize the option, just skip for
 			// now. This is okay because RFC 4861 allows us to
@@ -886,6 +928,87 @@ func (o NDPDNSSearchList) iterDomainNames(fn func(string)) error {
 	return nil
 }
 
+// NDPPref64 is the NDP PREF64 option, as defined by RFC 8781 section 4. It
+// carries the NAT64 prefix used by the network.
+//
+// The length, in bytes, of a valid NDP PREF64 option body MUST be
+// ndpPref64Length bytes.
+type NDPPref64 []byte
+
+// Type implements NDPOption.Type.
+func (NDPPref64) Type() NDPOptionIdentifier {
+	return NDPPref64OptionType
+}
+
+// Length implements NDPOption.Length.
+func (o NDPPref64) Length() int {
+	return len(o)
+}
+
+// serializeInto implements NDPOption.serializeInto.
+func (o NDPPref64) serializeInto(b []byte) int {
+	return copy(b, o)
+}
+
+// String implements fmt.Stringer.String.
+func (o NDPPref64) String() string {
+	lt := o.Lifetime()
+	subnet, err := o.Subnet()
+	if err != nil {
+		return fmt.Sprintf("%T(invalid prefix valid for %s; err = %s)", o, lt, err)
+	}
+	return fmt.Sprintf("%T(%s valid for %s)", o, subnet, lt)
+}
+
+// Lifetime returns the length of time that the NAT64 prefix in this option
+// may be used.
+//
+// Note, a value of 0 implies the prefix should no longer be used.
+func (o NDPPref64) Lifetime() time.Duration {
+	// The field is in units of 8 seconds, as per RFC 8781 section 4.
+	scaled := binary.BigEndian.Uint16(o[ndpPref64LifetimeOffset:]) >> ndpPref64LifetimeShift
+	return ndpPref64LifetimeUnit * time.Duration(scaled)
+}
+
+// PrefixLength returns the length of the NAT64 prefix encoded by the Prefix
+// Length Code field, as per RFC 8781 section 4.
+//
+// Returns false if the Prefix Length Code is not a valid value, in which case
+// the option MUST be ignored.
+func (o NDPPref64) PrefixLength() (int, bool) {
+	switch binary.BigEndian.Uint16(o[ndpPref64LifetimeOffset:]) & ndpPref64PLCMask {
+	case 0:
+		return 96, true
+	case 1:
+		return 64, true
+	case 2:
+		return 56, true
+	case 3:
+		return 48, true
+	case 4:
+		return 40, true
+	case 5:
+		return 32, true
+	default:
+		return 0, false
+	}
+}
+
+// Subnet returns the NAT64 prefix held in this option.
+func (o NDPPref64) Subnet() (tcpip.Subnet, error) {
+	prefixLen, ok := o.PrefixLength()
+	if !ok {
+		return tcpip.Subnet{}, fmt.Errorf("invalid Prefix Length Code in NDP PREF64 option: %w", ErrNDPOptMalformedBody)
+	}
+
+	var addr [IPv6AddressSize]byte
+	copy(addr[:], o[ndpPref64PrefixOffset:][:ndpPref64PrefixSize])
+	return tcpip.AddressWithPrefix{
+		Address:   tcpip.Address(addr[:]),
+		PrefixLen: prefixLen,
+	}.Subnet(), nil
+}
+
 func isLetter(b byte) bool {
 	return b >= 'a' && b <= 'z' || isUpperLetter(b)
 }
@@ -1140,6 +1140,138 @@ func TestNDPDNSSearchListOptionSerialize(t *testing.T) {
 	}
 }
 
+// TestNDPPref64Option tests the getters of NDPPref64.
+func TestNDPPref64Option(t *testing.T) {
+	tests := []struct {
+		name        string
+		buf         []byte
+		lifetime    time.Duration
+		prefixLen   int
+		prefixValid bool
+		subnet      tcpip.Subnet
+	}{
+		{
+			name: "96-bit prefix",
+			buf: []byte{
+				38, 2,
+				// Scaled lifetime = 75 (600s), PLC = 0.
+				0x02, 0x58,
+				0x00, 0x64, 0xff, 0x9b, 0, 0, 0, 0, 0, 0, 0, 0,
+			},
+			lifetime:    600 * time.Second,
+			prefixLen:   96,
+			prefixValid: true,
+			subnet: tcpip.AddressWithPrefix{
+				Address:   "\x00\x64\xff\x9b\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00",
+				PrefixLen: 96,
+			}.Subnet(),
+		},
+		{
+			name: "32-bit prefix",
+			buf: []byte{
+				38, 2,
+				// Scaled lifetime = 1 (8s), PLC = 5.
+				0x00, 0x0d,
+				0x20, 0x01, 0x0d, 0xb8, 1, 2, 3, 4, 5, 6, 7, 8,
+			},
+			lifetime:    8 * time.Second,
+			prefixLen:   32,
+			prefixValid: true,
+			subnet: tcpip.AddressWithPrefix{
+				Address:   "\x20\x01\x0d\xb8\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00",
+				PrefixLen: 32,
+			}.Subnet(),
+		},
+		{
+			name: "invalid PLC",
+			buf: []byte{
+				38, 2,
+				// Scaled lifetime = 0, PLC = 6.
+				0x00, 0x06,
+				0x20, 0x01, 0x0d, 0xb8, 0, 0, 0, 0, 0, 0, 0, 0,
+			},
+			lifetime:    0,
+			prefixValid: false,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			opts := NDPOptions(test.buf)
+			it, err := opts.Iter(true)
+			if err != nil {
+				t.Fatalf("got Iter = (_, %s), want = (_, nil)", err)
+			}
+
+			// Iterator should get our option.
+			next, done, err := it.Next()
+			if err != nil {
+				t.Fatalf("got Next = (_, _, %s), want = (_, _, nil)", err)
+			}
+			if done {
+				t.Fatal("got Next = (_, true, _), want = (_, false, _)")
+			}
+			if got := next.Type(); got != NDPPref64OptionType {
+				t.Fatalf("got Type = %d, want = %d", got, NDPPref64OptionType)
+			}
+
+			opt, ok := next.(NDPPref64)
+			if !ok {
+				t.Fatalf("next (type = %T) cannot be casted to an NDPPref64", next)
+			}
+			if got := opt.Lifetime(); got != test.lifetime {
+				t.Errorf("got Lifetime = %s, want = %s", got, test.lifetime)
+			}
+			prefixLen, ok := opt.PrefixLength()
+			if ok != test.prefixValid {
+				t.Fatalf("got PrefixLength = (_, %t), want = (_, %t)", ok, test.prefixValid)
+			}
+			subnet, err := opt.Subnet()
+			if !test.prefixValid {
+				if err == nil {
+					t.Errorf("got Subnet = (%s, nil), want = (_, non-nil)", subnet)
+				}
+				return
+			}
+			if prefixLen != test.prefixLen {
+				t.Errorf("got PrefixLength = (%d, _), want = (%d, _)", prefixLen, test.prefixLen)
+			}
+			if err != nil {
+				t.Fatalf("opt.Subnet() = %s", err)
+			}
+			if subnet != test.subnet {
+				t.Errorf("got Subnet = %s, want = %s", subnet, test.subnet)
+			}
+
+			// Iterator should not return anything else.
+			next, done, err = it.Next()
+			if err != nil {
+				t.Errorf("got Next = (_, _, %s), want = (_, _, nil)", err)
+			}
+			if !done {
+				t.Error("got Next = (_, false, _), want = (_, true, _)")
+			}
+			if next != nil {
+				t.Errorf("got Next = (%x, _, _), want = (nil, _, _)", next)
+			}
+		})
+	}
+}
+
+// TestNDPPref64OptionMalformed tests that an NDP PREF64 option with an
+// unexpected length is rejected.
+func TestNDPPref64OptionMalformed(t *testing.T) {
+	buf := []byte{
+		38, 3,
+		0, 0,
+		0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0,
+		0, 0, 0, 0, 0, 0, 0, 0,
+	}
+	if _, err := NDPOptions(buf).Iter(true); !errors.Is(err, ErrNDPOptMalformedBody) {
+		t.Fatalf("got Iter = (_, %v), want = (_, %s)", err, ErrNDPOptMalformedBody)
+	}
+}
+
 // TestNDPOptionsIterCheck tests that Iter will return false if the NDPOptions
 // the iterator was returned for is malformed.
 func TestNDPOptionsIterCheck(t *testing.T) {
@@ -26,11 +26,13 @@ func _() {
 	_ = x[NDPTargetLinkLayerAddressOptionType-2]
 	_ = x[NDPPrefixInformationType-3]
 	_ = x[NDPRecursiveDNSServerOptionType-25]
+	_ = x[NDPPref64OptionType-38]
 }
 
 const (
 	_NDPOptionIdentifier_name_0 = "NDPSourceLinkLayerAddressOptionTypeNDPTargetLinkLayerAddressOptionTypeNDPPrefixInformationType"
 	_NDPOptionIdentifier_name_1 = "NDPRecursiveDNSServerOptionType"
+	_NDPOptionIdentifier_name_2 = "NDPPref64OptionType"
 )
 
 var (
@@ -44,6 +46,8 @@ func (i NDPOptionIdentifier) String() string {
 		return _NDPOptionIdentifier_name_0[_NDPOptionIdentifier_index_0[i]:_NDPOptionIdentifier_index_0[i+1]]
 	case i == 25:
 		return _NDPOptionIdentifier_name_1
+	case i == 38:
+		return _NDPOptionIdentifier_name_2
 	default:
 		return "NDPOptionIdentifier(" + strconv.FormatInt(int64(i), 10) + ")"
 	}
@@ -15,10 +15,12 @@
 package header
 
 import (
+	"crypto/md5"
 	"encoding/binary"
 
 	"github.com/google/btree"
 	"gvisor.dev/gvisor/pkg/tcpip"
+	"gvisor.dev/gvisor/pkg/tcpip/buffer"
 	"gvisor.dev/gvisor/pkg/tcpip/seqnum"
 )
 
@@ -53,8 +55,15 @@ const (
 	TCPFlagPsh
 	TCPFlagAck
 	TCPFlagUrg
+	TCPFlagEce
+	TCPFlagCwr
 )
 
+// tcpFlagAE is the AE (Accurate ECN) flag, formerly known as NS. It does not
+// fit in the flags field and is instead the least significant bit of the
+// byte holding the data offset, see TCP.AE.
+const tcpFlagAE = 1
+
 // Options that may be present in a TCP segment.
 const (
 	TCPOptionEOL           = 0
@@ -64,6 +73,8 @@ const (
 	TCPOptionTS            = 8
 	TCPOptionSACKPermitted = 4
 	TCPOptionSACK          = 5
+	TCPOptionMD5           = 19
+	TCPOptionFastOpen      = 34
 )
 
 // Option Lengths.
@@ -72,6 +83,18 @@ const (
 	TCPOptionTSLength            = 10
 	TCPOptionWSLength            = 3
 	TCPOptionSackPermittedLength = 2
+	TCPOptionMD5Length           = 18
+	TCPOptionFastOpenMinLength   = 2
+)
+
+// TCPMD5DigestSize is the size of the digest carried in the TCP MD5 signature
+// option, as specified in RFC 2385, section 3.0.
+const TCPMD5DigestSize = md5.Size
+
+// TCP Fast Open cookie lengths, as specified in RFC 7413, section 4.1.1.
+const (
+	TCPFastOpenCookieMinLength = 4
+	TCPFastOpenCookieMaxLength = 16
 )
 
 // TCPFields contains the fields of a TCP packet. It is used to describe the
@@ -96,6 +119,9 @@ type TCPFields struct {
 	// Flags is the "flags" field of a TCP packet.
 	Flags uint8
 
+	// AE is the AE (Accurate ECN) flag of a TCP packet.
+	AE bool
+
 	// WindowSize is the "window size" field of a TCP packet.
 	WindowSize uint16
 
@@ -128,6 +154,14 @@ type TCPSynOptions struct {
 
 	// SACKPermitted is true if the SACK option was provided in the SYN/SYN-ACK.
 	SACKPermitted bool
+
+	// FastOpen is true if the TCP Fast Open option was provided in the
+	// SYN/SYN-ACK.
+	FastOpen bool
+
+	// FastOpenCookie is the cookie carried in the TCP Fast Open option. It
+	// is empty if the option was a cookie request.
+	FastOpenCookie []byte
 }
 
 // SACKBlock represents a single contiguous SACK block.
@@ -238,6 +272,13 @@ func (b TCP) Flags() uint8 {
 	return b[TCPFlagsOffset]
 }
 
+// AE returns the AE (Accurate ECN) flag of the tcp header.
+//
+// See: https://tools.ietf.org/html/draft-ietf-tcpm-accurate-ecn-13#section-3.1.1.
+func (b TCP) AE() bool {
+	return b[TCPDataOffset]&tcpFlagAE != 0
+}
+
 // WindowSize returns the "window size" field of the tcp header.
 func (b TCP) WindowSize() uint16 {
 	return binary.BigEndian.Uint16(b[TCPWinSizeOffset:])
@@ -289,6 +330,15 @@ func (b TCP) SetFlags(flags uint8) {
 	b[TCPFlagsOffset] = flags
 }
 
+// SetAE sets the AE (Accurate ECN) flag of the tcp header.
+func (b TCP) SetAE(ae bool) {
+	if ae {
+		b[TCPDataOffset] |= tcpFlagAE
+	} else {
+		b[TCPDataOffset] &^= tcpFlagAE
+	}
+}
+
 // SetWindowSize sets the window size field of the tcp header.
 func (b TCP) SetWindowSize(rcvwnd uint16) {
 	binary.BigEndian.PutUint16(b[TCPWinSizeOffset:], rcvwnd)
@@ -332,6 +382,7 @@ func (b TCP) Encode(t *TCPFields) {
 	binary.BigEndian.PutUint16(b[TCPSrcPortOffset:], t.SrcPort)
 	binary.BigEndian.PutUint16(b[TCPDstPortOffset:], t.DstPort)
 	b[TCPDataOffset] = (t.DataOffset / 4) << 4
+	b.SetAE(t.AE)
 	binary.BigEndian.PutUint16(b[TCPChecksumOffset:], t.Checksum)
 	binary.BigEndian.PutUint16(b[TCPUrgentPtrOffset:], t.UrgentPointer)
 }
@@ -422,6 +473,23 @@ func ParseSynOptions(opts []byte, isAck bool) TCPSynOptions {
 			synOpts.SACKPermitted = true
 			i += 2
 
+		case TCPOptionFastOpen:
+			if i+TCPOptionFastOpenMinLength > limit {
+				return synOpts
+			}
+			l := int(opts[i+1])
+			if l < TCPOptionFastOpenMinLength || i+l > limit {
+				return synOpts
+			}
+			// RFC 7413, section 4.1.1: a cookie is either absent (a
+			// cookie request) or between 4 and 16 bytes long and a
+			// multiple of 2. Malformed cookies are ignored.
+			if cl := l - TCPOptionFastOpenMinLength; cl == 0 || (cl >= TCPFastOpenCookieMinLength && cl <= TCPFastOpenCookieMaxLength && cl%2 == 0) {
+				synOpts.FastOpen = true
+				synOpts.FastOpenCookie = append([]byte(nil), opts[i+2:i+l]...)
+			}
+			i += l
+
 		default:
 			// We don't recognize this option, just skip over it.
 			if i+2 > limit {
@@ -497,6 +565,35 @@ func ParseTCPOptions(b []byte) TCPOptions {
 	return opts
 }
 
+// ParseMD5Option returns the digest carried in the TCP MD5 signature option in
+// the provided options, or nil if there is no such option or it is malformed.
+func ParseMD5Option(b []byte) []byte {
+	limit := len(b)
+	for i := 0; i < limit; {
+		switch b[i] {
+		case TCPOptionEOL:
+			return nil
+		case TCPOptionNOP:
+			i++
+		case TCPOptionMD5:
+			if i+TCPOptionMD5Length > limit || b[i+1] != TCPOptionMD5Length {
+				return nil
+			}
+			return b[i+2 : i+TCPOptionMD5Length]
+		default:
+			if i+2 > limit {
+				return nil
+			}
+			l := int(b[i+1])
+			if l < 2 || i+l > limit {
+				return nil
+			}
+			i += l
+		}
+	}
+	return nil
+}
+
 // EncodeMSSOption encodes the MSS TCP option with the provided MSS values in
 // the supplied buffer. If the provided buffer is not large enough then it just
 // returns without encoding anything. It returns the number of bytes written to
@@ -548,6 +645,78 @@ func EncodeSACKPermittedOption(b []byte) int {
 	return int(b[1])
 }
 
+// EncodeFastOpenOption encodes a TCP Fast Open option carrying the provided
+// cookie into the provided buffer. An empty cookie encodes a cookie request.
+// If the buffer is smaller than required it just returns without encoding
+// anything. It returns the number of bytes written to the provided buffer.
+func EncodeFastOpenOption(cookie []byte, b []byte) int {
+	l := TCPOptionFastOpenMinLength + len(cookie)
+	if len(b) < l {
+		return 0
+	}
+	b[0], b[1] = TCPOptionFastOpen, byte(l)
+	copy(b[2:], cookie)
+	return l
+}
+
+// EncodeMD5Option encodes a TCP MD5 signature option with an all zero digest
+// into the provided buffer. The digest is filled in once the segment is
+// complete, see TCPMD5Digest. If the buffer is smaller than required it just
+// returns without encoding anything. It returns the number of bytes written
+// to the provided buffer.
+func EncodeMD5Option(b []byte) int {
+	if len(b) < TCPOptionMD5Length {
+		return 0
+	}
+	b[0], b[1] = TCPOptionMD5, TCPOptionMD5Length
+	for i := 2; i < TCPOptionMD5Length; i++ {
+		b[i] = 0
+	}
+	return TCPOptionMD5Length
+}
+
+// TCPMD5Digest computes the TCP MD5 signature (RFC 2385, section 2.0) of the
+// segment made of the TCP header h and the payload data sent from src to dst
+// using the provided key. The options in h and its checksum are not part of
+// the signature.
+//
+// For IPv6 the pseudo-header is the one used for the TCP checksum, as done by
+// Linux.
+func TCPMD5Digest(src, dst tcpip.Address, h TCP, data buffer.VectorisedView, key []byte) [TCPMD5DigestSize]byte {
+	segLen := len(h) + data.Size()
+	d := md5.New()
+
+	// The pseudo-header.
+	d.Write([]byte(src))
+	d.Write([]byte(dst))
+	if len(src) == IPv4AddressSize {
+		var ph [4]byte
+		ph[1] = uint8(TCPProtocolNumber)
+		binary.BigEndian.PutUint16(ph[2:], uint16(segLen))
+		d.Write(ph[:])
+	} else {
+		var ph [8]byte
+		binary.BigEndian.PutUint32(ph[:], uint32(segLen))
+		binary.BigEndian.PutUint32(ph[4:], uint32(TCPProtocolNumber))
+		d.Write(ph[:])
+	}
+
+	// The TCP header without options and with a zero checksum.
+	var th [TCPMinimumSize]byte
+	copy(th[:], h)
+	th[TCPChecksumOffset], th[TCPChecksumOffset+1] = 0, 0
+	d.Write(th[:])
+
+	for _, v := range data.Views() {
+		d.Write(v)
+	}
+	d.Write(key)
+
+	var digest [TCPMD5DigestSize]byte
+	copy(digest[:], d.Sum(nil))
+	return digest
+}
+
 // EncodeSACKBlocks encodes the provided SACK blocks as a TCP SACK option block
 // in the provided slice. It tries to fit in as many blocks as possible based on
 // number of bytes available in the provided buffer. It returns the number of
@@ -15,9 +15,13 @@
 package header_test
 
 import (
+	"bytes"
+	"crypto/md5"
 	"reflect"
 	"testing"
 
+	"gvisor.dev/gvisor/pkg/tcpip"
+	"gvisor.dev/gvisor/pkg/tcpip/buffer"
 	"gvisor.dev/gvisor/pkg/tcpip/header"
 )
 
@@ -146,3 +150,113 @@ func TestTCPParseOptions(t *testing.T) {
 		}
 	}
 }
+
+func TestParseSynOptionsFastOpen(t *testing.T) {
+	for _, tc := range []struct {
+		name       string
+		b          []byte
+		wantOpt    bool
+		wantCookie []byte
+	}{
+		{"NoOption", []byte{header.TCPOptionNOP}, false, nil},
+		{"CookieRequest", []byte{header.TCPOptionFastOpen, 2}, true, []byte{}},
+		{"Cookie", []byte{header.TCPOptionFastOpen, 6, 1, 2, 3, 4}, true, []byte{1, 2, 3, 4}},
+		{"OddCookie", []byte{header.TCPOptionFastOpen, 7, 1, 2, 3, 4, 5}, false, nil},
+		{"ShortCookie", []byte{header.TCPOptionFastOpen, 4, 1, 2}, false, nil},
+		{"Truncated", []byte{header.TCPOptionFastOpen, 6, 1, 2}, false, nil},
+		{"BadLength", []byte{header.TCPOptionFastOpen, 1}, false, nil},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			opts := header.ParseSynOptions(tc.b, false /* isAck */)
+			if opts.FastOpen != tc.wantOpt {
+				t.Fatalf("got FastOpen = %t, want = %t", opts.FastOpen, tc.wantOpt)
+			}
+			if tc.wantOpt && !bytes.Equal(opts.FastOpenCookie, tc.wantCookie) {
+				t.Errorf("got FastOpenCookie = %v, want = %v", opts.FastOpenCookie, tc.wantCookie)
+			}
+		})
+	}
+}
+
+func TestEncodeFastOpenOption(t *testing.T) {
+	cookie := []byte{1, 2, 3, 4, 5, 6, 7, 8}
+	b := make([]byte, header.TCPOptionFastOpenMinLength+len(cookie))
+	if got, want := header.EncodeFastOpenOption(cookie, b), len(b); got != want {
+		t.Fatalf("got EncodeFastOpenOption(%v, _) = %d, want = %d", cookie, got, want)
+	}
+	opts := header.ParseSynOptions(b, true /* isAck */)
+	if !opts.FastOpen || !reflect.DeepEqual(opts.FastOpenCookie, cookie) {
+		t.Errorf("got ParseSynOptions(%v) = %+v, want FastOpen cookie %v", b, opts, cookie)
+	}
+	if got := header.EncodeFastOpenOption(cookie, b[:len(b)-1]); got != 0 {
+		t.Errorf("got EncodeFastOpenOption(%v, short buffer) = %d, want = 0", cookie, got)
+	}
+}
+
+func TestParseMD5Option(t *testing.T) {
+	digest := []byte{1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16}
+	md5Opt := append([]byte{header.TCPOptionMD5, header.TCPOptionMD5Length}, digest...)
+	for _, tc := range []struct {
+		name string
+		b    []byte
+		want []byte
+	}{
+		{"NoOption", []byte{header.TCPOptionNOP, header.TCPOptionNOP}, nil},
+		{"MD5", append([]byte{header.TCPOptionNOP, header.TCPOptionNOP}, md5Opt...), digest},
+		{"AfterMSS", append([]byte{header.TCPOptionMSS, 4, 5, 180}, md5Opt...), digest},
+		{"AfterEOL", append([]byte{header.TCPOptionEOL}, md5Opt...), nil},
+		{"Truncated", md5Opt[:header.TCPOptionMD5Length-1], nil},
+		{"BadLength", append([]byte{header.TCPOptionMD5, 10}, digest...), nil},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := header.ParseMD5Option(tc.b); !bytes.Equal(got, tc.want) {
+				t.Errorf("got ParseMD5Option(%v) = %v, want = %v", tc.b, got, tc.want)
+			}
+		})
+	}
+}
+
+func TestTCPMD5Digest(t *testing.T) {
+	const (
+		src = tcpip.Address("\x0a\x00\x00\x01")
+		dst = tcpip.Address("\x0a\x00\x00\x02")
+	)
+	key := []byte("secret")
+	payload := []byte("payload")
+
+	h := header.TCP(make([]byte, header.TCPMinimumSize+header.TCPOptionMD5Length+2))
+	h.Encode(&header.TCPFields{
+		SrcPort:    179,
+		DstPort:    40000,
+		SeqNum:     1,
+		AckNum:     2,
+		DataOffset: uint8(len(h)),
+		Flags:      header.TCPFlagAck,
+		WindowSize: 1000,
+		Checksum:   0xffff,
+	})
+	h[header.TCPMinimumSize] = header.TCPOptionNOP
+	h[header.TCPMinimumSize+1] = header.TCPOptionNOP
+	header.EncodeMD5Option(h[header.TCPMinimumSize+2:])
+	data := buffer.View(payload).ToVectorisedView()
+
+	// RFC 2385, section 2.0: the pseudo-header, the fixed header with a
+	// zero checksum, the payload and the key, in this order.
+	var want bytes.Buffer
+	want.WriteString(string(src))
+	want.WriteString(string(dst))
+	want.Write([]byte{0, uint8(header.TCPProtocolNumber), 0, byte(len(h) + len(payload))})
+	fixed := append([]byte(nil), h[:header.TCPMinimumSize]...)
+	fixed[header.TCPChecksumOffset], fixed[header.TCPChecksumOffset+1] = 0, 0
+	want.Write(fixed)
+	want.Write(payload)
+	want.Write(key)
+
+	got := header.TCPMD5Digest(src, dst, h, data, key)
+	if wantDigest := md5.Sum(want.Bytes()); got != wantDigest {
+		t.Errorf("got TCPMD5Digest(...) = %x, want = %x", got, wantDigest)
+	}
+	if other := header.TCPMD5Digest(src, dst, h, data, []byte("other")); other == got {
+		t.Errorf("got the same digest %x for different keys", got)
+	}
+}
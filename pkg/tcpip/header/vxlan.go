@@ -0,0 +1,61 @@
+// Copyright 2021 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package header
+
+import "encoding/binary"
+
+const (
+	vxlanFlags = 0
+	vxlanVNI   = 4
+)
+
+// vxlanFlagVNI is the I flag, which indicates that the VNI is valid.
+const vxlanFlagVNI = 0x08
+
+// VXLAN represents a Virtual eXtensible Local Area Network header stored in a
+// byte array, the fields are described in RFC 7348 section 5.
+type VXLAN []byte
+
+const (
+	// VXLANMinimumSize is the size of a valid VXLAN header.
+	VXLANMinimumSize = 8
+
+	// VXLANPort is the UDP port assigned to VXLAN by IANA.
+	VXLANPort = 4789
+
+	// MaxVNI is the largest VXLAN or GENEVE network identifier.
+	MaxVNI = 1<<24 - 1
+)
+
+// IsValid returns true if the I flag, which indicates a valid VNI, is set.
+// Other flags are reserved and ignored on receipt.
+func (b VXLAN) IsValid() bool {
+	return b[vxlanFlags]&vxlanFlagVNI != 0
+}
+
+// VNI returns the VXLAN network identifier.
+func (b VXLAN) VNI() uint32 {
+	return binary.BigEndian.Uint32(b[vxlanVNI:]) >> 8
+}
+
+// Encode encodes the VXLAN header with the network identifier vni. All
+// reserved fields are zeroed.
+func (b VXLAN) Encode(vni uint32) {
+	for i := range b[:VXLANMinimumSize] {
+		b[i] = 0
+	}
+	b[vxlanFlags] = vxlanFlagVNI
+	binary.BigEndian.PutUint32(b[vxlanVNI:], vni<<8)
+}
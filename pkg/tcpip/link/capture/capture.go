@@ -0,0 +1,356 @@
+// Copyright 2021 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package capture provides a link layer endpoint that wraps another endpoint
+// and copies the packets traversing it to a packet capture. Unlike the sniffer
+// endpoint, captures can be started and stopped while the stack is running,
+// and only capture the packets matching a BPF filter.
+package capture
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"gvisor.dev/gvisor/pkg/abi/linux"
+	"gvisor.dev/gvisor/pkg/bpf"
+	"gvisor.dev/gvisor/pkg/sync"
+	"gvisor.dev/gvisor/pkg/tcpip"
+	"gvisor.dev/gvisor/pkg/tcpip/buffer"
+	"gvisor.dev/gvisor/pkg/tcpip/link/nested"
+	"gvisor.dev/gvisor/pkg/tcpip/stack"
+)
+
+// DefaultSnapLen is the maximum number of bytes captured from each packet if
+// none is specified.
+const DefaultSnapLen = 262144
+
+// File is a file that packets are captured to.
+type File interface {
+	io.Writer
+	io.Seeker
+
+	// Truncate changes the size of the file.
+	Truncate(size int64) error
+}
+
+// Options are the options of a capture.
+type Options struct {
+	// Filter is the classic BPF program selecting the captured packets.
+	// Like filters for LINKTYPE_RAW, it runs on packets starting at their
+	// network header, and returns the number of bytes of the packet to
+	// capture. All packets are captured if Filter is empty.
+	Filter []linux.BPFInstruction
+
+	// SnapLen is the maximum number of bytes captured from each packet. If
+	// zero, DefaultSnapLen is used.
+	SnapLen uint32
+
+	// Files are the files packets are captured to, in the pcapng format.
+	// Once a file reaches FileSize, the capture continues in the next file,
+	// wrapping around to the first one after the last, which is then
+	// overwritten.
+	Files []File
+
+	// FileSize is the maximum size of each file. If zero, all packets are
+	// captured to the first file.
+	FileSize int64
+}
+
+// Capture is a packet capture in progress on a set of endpoints.
+type Capture struct {
+	endpoints []*Endpoint
+	filter    bpf.Program
+	hasFilter bool
+	snapLen   uint32
+	fileSize  int64
+
+	// headers holds the section header and interface description blocks
+	// written at the start of each file. It is immutable.
+	headers []byte
+
+	// mu protects the fields below.
+	mu sync.Mutex
+
+	// files are the files packets are captured to, and file is the index of
+	// the current one.
+	files []File
+	file  int
+
+	// written is the number of bytes written to the current file.
+	written int64
+
+	// packets is the number of packets captured.
+	packets uint64
+
+	// err is the first error writing to the files. Packets aren't
+	// captured anymore once it is set.
+	err error
+}
+
+// Start starts capturing the packets traversing endpoints.
+//
+// Only one capture may be in progress on an endpoint at a time.
+func Start(endpoints []*Endpoint, opts Options) (*Capture, error) {
+	if len(opts.Files) == 0 {
+		return nil, fmt.Errorf("no capture file")
+	}
+	c := &Capture{
+		snapLen:  opts.SnapLen,
+		fileSize: opts.FileSize,
+		files:    opts.Files,
+	}
+	if c.snapLen == 0 {
+		c.snapLen = DefaultSnapLen
+	}
+	if len(opts.Filter) != 0 {
+		filter, err := bpf.Compile(opts.Filter)
+		if err != nil {
+			return nil, fmt.Errorf("invalid capture filter: %v", err)
+		}
+		c.filter = filter
+		c.hasFilter = true
+	}
+
+	c.headers = sectionHeaderBlock()
+	for _, ep := range endpoints {
+		c.headers = append(c.headers, interfaceDescriptionBlock(ep.name, c.snapLen)...)
+	}
+	if err := c.startFile(); err != nil {
+		return nil, err
+	}
+
+	for i, ep := range endpoints {
+		if !ep.attach(&attachment{capture: c, ifIndex: uint32(i)}) {
+			c.detach()
+			return nil, fmt.Errorf("a capture is already in progress on %q", ep.name)
+		}
+		c.endpoints = append(c.endpoints, ep)
+	}
+	return c, nil
+}
+
+// Stop stops the capture. It returns the number of packets captured and the
+// first error encountered writing them, if any.
+func (c *Capture) Stop() (uint64, error) {
+	c.detach()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.packets, c.err
+}
+
+func (c *Capture) detach() {
+	for _, ep := range c.endpoints {
+		ep.detach()
+	}
+	c.endpoints = nil
+}
+
+// startFile truncates the current file and writes the headers to it.
+//
+// Precondition: c.mu must be locked, or c must not be attached yet.
+func (c *Capture) startFile() error {
+	f := c.files[c.file]
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	if err := f.Truncate(0); err != nil {
+		return err
+	}
+	c.written = 0
+	return c.write(c.headers)
+}
+
+// write writes b to the current file.
+//
+// Precondition: c.mu must be locked, or c must not be attached yet.
+func (c *Capture) write(b []byte) error {
+	n, err := c.files[c.file].Write(b)
+	c.written += int64(n)
+	return err
+}
+
+// capture captures pkt if it matches the filter.
+func (c *Capture) capture(ifIndex uint32, flags uint32, pkt *stack.PacketBuffer) {
+	// Captured packets start at their network header, as they don't have a
+	// link header on all interfaces.
+	vv := buffer.NewVectorisedView(pkt.Size(), pkt.Views())
+	vv.TrimFront(len(pkt.LinkHeader().View()))
+	data := vv.ToView()
+
+	length := len(data)
+	if c.hasFilter {
+		ret, err := bpf.Exec(c.filter, bpf.InputBytes{Data: data, Order: binary.BigEndian})
+		if err != nil || ret == 0 {
+			// As in Linux, filters loading out of the packet bounds
+			// reject it.
+			return
+		}
+		if int64(ret) < int64(length) {
+			length = int(ret)
+		}
+	}
+	if int64(c.snapLen) < int64(length) {
+		length = int(c.snapLen)
+	}
+	block := enhancedPacketBlock(ifIndex, time.Now(), flags, data[:length], len(data))
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.err != nil {
+		return
+	}
+	if c.fileSize > 0 && c.written > int64(len(c.headers)) && c.written+int64(len(block)) > c.fileSize {
+		c.file = (c.file + 1) % len(c.files)
+		if c.err = c.startFile(); c.err != nil {
+			return
+		}
+	}
+	if c.err = c.write(block); c.err != nil {
+		return
+	}
+	c.packets++
+}
+
+// attachment is a capture attached to an endpoint.
+type attachment struct {
+	capture *Capture
+
+	// ifIndex is the index of the endpoint in the interfaces of the
+	// capture.
+	ifIndex uint32
+}
+
+// Endpoint is a link endpoint that copies the packets traversing it to the
+// capture attached to it, if any.
+type Endpoint struct {
+	nested.Endpoint
+
+	// name is the name of the interface in captures. It is immutable.
+	name string
+
+	// mu serializes attaching and detaching captures.
+	mu sync.Mutex
+
+	// attached holds the *attachment of the capture in progress, or nil.
+	// It is only modified with mu locked, but may be loaded without.
+	attached atomic.Value
+}
+
+var _ stack.GSOEndpoint = (*Endpoint)(nil)
+var _ stack.LinkEndpoint = (*Endpoint)(nil)
+var _ stack.NetworkDispatcher = (*Endpoint)(nil)
+
+// New creates a new capture endpoint wrapping lower. Packets are captured as
+// traversing an interface named name.
+func New(lower stack.LinkEndpoint, name string) *Endpoint {
+	e := &Endpoint{name: name}
+	e.attached.Store((*attachment)(nil))
+	e.Endpoint.Init(lower, e)
+	return e
+}
+
+func (e *Endpoint) attach(a *attachment) bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.attached.Load().(*attachment) != nil {
+		return false
+	}
+	e.attached.Store(a)
+	return true
+}
+
+func (e *Endpoint) detach() {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.attached.Store((*attachment)(nil))
+}
+
+func (e *Endpoint) capture(flags uint32, pkt *stack.PacketBuffer) {
+	if a := e.attached.Load().(*attachment); a != nil {
+		a.capture.capture(a.ifIndex, flags, pkt)
+	}
+}
+
+// DeliverNetworkPacket implements stack.NetworkDispatcher.DeliverNetworkPacket.
+func (e *Endpoint) DeliverNetworkPacket(remote, local tcpip.LinkAddress, protocol tcpip.NetworkProtocolNumber, pkt *stack.PacketBuffer) {
+	e.capture(epbFlagsInbound, pkt)
+	e.Endpoint.DeliverNetworkPacket(remote, local, protocol, pkt)
+}
+
+// WritePacket implements stack.LinkEndpoint.WritePacket.
+func (e *Endpoint) WritePacket(r stack.RouteInfo, gso *stack.GSO, protocol tcpip.NetworkProtocolNumber, pkt *stack.PacketBuffer) *tcpip.Error {
+	e.capture(epbFlagsOutbound, pkt)
+	return e.Endpoint.WritePacket(r, gso, protocol, pkt)
+}
+
+// WritePackets implements stack.LinkEndpoint.WritePackets.
+func (e *Endpoint) WritePackets(r stack.RouteInfo, gso *stack.GSO, pkts stack.PacketBufferList, protocol tcpip.NetworkProtocolNumber) (int, *tcpip.Error) {
+	for pkt := pkts.Front(); pkt != nil; pkt = pkt.Next() {
+		e.capture(epbFlagsOutbound, pkt)
+	}
+	return e.Endpoint.WritePackets(r, gso, pkts, protocol)
+}
+
+// ParseFilter parses a classic BPF program in the format printed by
+// "tcpdump -ddd": the number of instructions followed by one instruction per
+// line, each made of its opcode, jt, jf and k fields in decimal. Lines may
+// also be separated by commas.
+func ParseFilter(s string) ([]linux.BPFInstruction, error) {
+	lines := strings.FieldsFunc(s, func(r rune) bool {
+		return r == '\n' || r == ','
+	})
+	var fields [][]string
+	for _, l := range lines {
+		if f := strings.Fields(l); len(f) != 0 {
+			fields = append(fields, f)
+		}
+	}
+	if len(fields) == 0 || len(fields[0]) != 1 {
+		return nil, fmt.Errorf("filter must start with its number of instructions")
+	}
+	count, err := strconv.Atoi(fields[0][0])
+	if err != nil {
+		return nil, fmt.Errorf("invalid number of instructions %q: %v", fields[0][0], err)
+	}
+	fields = fields[1:]
+	if count != len(fields) {
+		return nil, fmt.Errorf("filter has %d instructions, want %d", len(fields), count)
+	}
+
+	insns := make([]linux.BPFInstruction, 0, count)
+	for i, f := range fields {
+		if len(f) != 4 {
+			return nil, fmt.Errorf("instruction %d: got %d fields, want 4", i, len(f))
+		}
+		var v [4]uint64
+		for j, bits := range []int{16, 8, 8, 32} {
+			if v[j], err = strconv.ParseUint(f[j], 10, bits); err != nil {
+				return nil, fmt.Errorf("instruction %d: %v", i, err)
+			}
+		}
+		insns = append(insns, linux.BPFInstruction{
+			OpCode:      uint16(v[0]),
+			JumpIfTrue:  uint8(v[1]),
+			JumpIfFalse: uint8(v[2]),
+			K:           uint32(v[3]),
+		})
+	}
+	return insns, nil
+}
@@ -0,0 +1,305 @@
+// Copyright 2021 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package capture
+
+import (
+	"bytes"
+	"io"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"gvisor.dev/gvisor/pkg/abi/linux"
+	"gvisor.dev/gvisor/pkg/bpf"
+	"gvisor.dev/gvisor/pkg/tcpip"
+	"gvisor.dev/gvisor/pkg/tcpip/buffer"
+	"gvisor.dev/gvisor/pkg/tcpip/header"
+	"gvisor.dev/gvisor/pkg/tcpip/link/channel"
+	"gvisor.dev/gvisor/pkg/tcpip/stack"
+)
+
+// memFile is an in-memory File.
+type memFile struct {
+	b   []byte
+	off int64
+}
+
+// Write implements io.Writer.Write.
+func (f *memFile) Write(b []byte) (int, error) {
+	if end := f.off + int64(len(b)); end > int64(len(f.b)) {
+		f.b = append(f.b, make([]byte, end-int64(len(f.b)))...)
+	}
+	copy(f.b[f.off:], b)
+	f.off += int64(len(b))
+	return len(b), nil
+}
+
+// Seek implements io.Seeker.Seek.
+func (f *memFile) Seek(offset int64, whence int) (int64, error) {
+	if whence != io.SeekStart {
+		panic("unimplemented")
+	}
+	f.off = offset
+	return offset, nil
+}
+
+// Truncate implements File.Truncate.
+func (f *memFile) Truncate(size int64) error {
+	f.b = f.b[:size]
+	return nil
+}
+
+type capturedPacket struct {
+	IfIndex uint32
+	Flags   uint32
+	Data    []byte
+	OrigLen uint32
+}
+
+// parse returns the names of the interfaces and the packets of the capture
+// in f.
+func parse(t *testing.T, f *memFile) ([]string, []capturedPacket) {
+	t.Helper()
+	var (
+		names   []string
+		packets []capturedPacket
+	)
+	b := f.b
+	for len(b) != 0 {
+		if len(b) < 12 {
+			t.Fatalf("truncated block: %x", b)
+		}
+		blockType := byteOrder.Uint32(b)
+		length := byteOrder.Uint32(b[4:])
+		if length%4 != 0 || int(length) > len(b) || byteOrder.Uint32(b[length-4:]) != length {
+			t.Fatalf("invalid block length %d", length)
+		}
+		body := b[8 : length-4]
+		switch blockType {
+		case blockTypeSectionHeader:
+			if got := byteOrder.Uint32(body); got != sectionHeaderByteOrderMagic {
+				t.Fatalf("got byte order magic = %#x, want = %#x", got, sectionHeaderByteOrderMagic)
+			}
+		case blockTypeInterfaceDesc:
+			if got := byteOrder.Uint16(body); got != linkTypeRaw {
+				t.Fatalf("got link type = %d, want = %d", got, linkTypeRaw)
+			}
+			opts := body[8:]
+			var name string
+			for byteOrder.Uint16(opts) != optEndOfOpt {
+				code, l := byteOrder.Uint16(opts), int(byteOrder.Uint16(opts[2:]))
+				if code == optIfName {
+					name = string(opts[4 : 4+l])
+				}
+				opts = opts[4+pad4(l):]
+			}
+			names = append(names, name)
+		case blockTypeEnhancedPacket:
+			capLen := int(byteOrder.Uint32(body[12:]))
+			p := capturedPacket{
+				IfIndex: byteOrder.Uint32(body),
+				Data:    append([]byte(nil), body[20:20+capLen]...),
+				OrigLen: byteOrder.Uint32(body[16:]),
+			}
+			opts := body[20+pad4(capLen):]
+			if code := byteOrder.Uint16(opts); code != optEPBFlags {
+				t.Fatalf("got option code = %d, want = %d", code, optEPBFlags)
+			}
+			p.Flags = byteOrder.Uint32(opts[4:])
+			packets = append(packets, p)
+		default:
+			t.Fatalf("unexpected block type %#x", blockType)
+		}
+		b = b[length:]
+	}
+	return names, packets
+}
+
+// ipv4Packet returns an IPv4 packet with a payload of size bytes for
+// transport protocol proto.
+func ipv4Packet(proto tcpip.TransportProtocolNumber, size int) buffer.View {
+	v := buffer.NewView(header.IPv4MinimumSize + size)
+	header.IPv4(v).Encode(&header.IPv4Fields{
+		TotalLength: uint16(len(v)),
+		TTL:         64,
+		Protocol:    uint8(proto),
+		SrcAddr:     "\x0a\x00\x00\x01",
+		DstAddr:     "\x0a\x00\x00\x02",
+	})
+	return v
+}
+
+func newPacket(v buffer.View) *stack.PacketBuffer {
+	return stack.NewPacketBuffer(stack.PacketBufferOptions{
+		ReserveHeaderBytes: header.EthernetMinimumSize,
+		Data:               v.ToVectorisedView(),
+	})
+}
+
+type dispatcher struct {
+	delivered int
+}
+
+func (d *dispatcher) DeliverNetworkPacket(tcpip.LinkAddress, tcpip.LinkAddress, tcpip.NetworkProtocolNumber, *stack.PacketBuffer) {
+	d.delivered++
+}
+
+func (*dispatcher) DeliverOutboundPacket(tcpip.LinkAddress, tcpip.LinkAddress, tcpip.NetworkProtocolNumber, *stack.PacketBuffer) {
+}
+
+// udpFilter captures the first 30 bytes of IPv4 UDP packets.
+var udpFilter = []linux.BPFInstruction{
+	bpf.Stmt(bpf.Ld|bpf.Abs|bpf.B, 9),
+	bpf.Jump(bpf.Jmp|bpf.Jeq|bpf.K, uint32(header.UDPProtocolNumber), 0, 1),
+	bpf.Stmt(bpf.Ret|bpf.K, 30),
+	bpf.Stmt(bpf.Ret|bpf.K, 0),
+}
+
+func TestCapture(t *testing.T) {
+	lower0 := channel.New(10, 1500, "")
+	lower1 := channel.New(10, 1500, "")
+	eps := []*Endpoint{New(lower0, "eth0"), New(lower1, "eth1")}
+	var d dispatcher
+	for _, ep := range eps {
+		ep.Attach(&d)
+	}
+
+	// Packets traversing the endpoints before the capture starts aren't
+	// captured.
+	lower0.InjectInbound(header.IPv4ProtocolNumber, newPacket(ipv4Packet(header.UDPProtocolNumber, 10)))
+
+	var f memFile
+	c, err := Start(eps, Options{Filter: udpFilter, Files: []File{&f}})
+	if err != nil {
+		t.Fatalf("Start(...): %s", err)
+	}
+	if _, err := Start(eps[1:], Options{Files: []File{&memFile{}}}); err == nil {
+		t.Fatalf("got Start(...) with a capture in progress = nil, want error")
+	}
+
+	udpIn := ipv4Packet(header.UDPProtocolNumber, 100)
+	lower0.InjectInbound(header.IPv4ProtocolNumber, newPacket(udpIn))
+	lower0.InjectInbound(header.IPv4ProtocolNumber, newPacket(ipv4Packet(header.TCPProtocolNumber, 100)))
+	udpOut := ipv4Packet(header.UDPProtocolNumber, 5)
+	pkt := newPacket(udpOut)
+	// The link header of outbound packets isn't captured.
+	pkt.LinkHeader().Push(header.EthernetMinimumSize)
+	if err := eps[1].WritePacket(stack.RouteInfo{}, nil /* gso */, header.IPv4ProtocolNumber, pkt); err != nil {
+		t.Fatalf("WritePacket(...): %s", err)
+	}
+
+	n, err := c.Stop()
+	if err != nil {
+		t.Fatalf("Stop(): %s", err)
+	}
+	if n != 2 {
+		t.Errorf("got Stop() = %d packets, want = 2", n)
+	}
+	if d.delivered != 3 {
+		t.Errorf("got %d delivered packets, want = 3", d.delivered)
+	}
+
+	// Packets traversing the endpoints after the capture stops aren't
+	// captured.
+	lower1.InjectInbound(header.IPv4ProtocolNumber, newPacket(ipv4Packet(header.UDPProtocolNumber, 10)))
+
+	names, packets := parse(t, &f)
+	if diff := cmp.Diff([]string{"eth0", "eth1"}, names); diff != "" {
+		t.Errorf("interface names mismatch (-want +got):\n%s", diff)
+	}
+	want := []capturedPacket{
+		{IfIndex: 0, Flags: epbFlagsInbound, Data: udpIn[:30], OrigLen: uint32(len(udpIn))},
+		{IfIndex: 1, Flags: epbFlagsOutbound, Data: udpOut, OrigLen: uint32(len(udpOut))},
+	}
+	if diff := cmp.Diff(want, packets); diff != "" {
+		t.Errorf("captured packets mismatch (-want +got):\n%s", diff)
+	}
+
+	// Endpoints can be captured again once the capture stops.
+	c, err = Start(eps, Options{Files: []File{&memFile{}}})
+	if err != nil {
+		t.Fatalf("Start(...) after Stop(): %s", err)
+	}
+	c.Stop()
+}
+
+func TestCaptureRotation(t *testing.T) {
+	lower := channel.New(10, 1500, "")
+	ep := New(lower, "eth0")
+	ep.Attach(&dispatcher{})
+
+	files := []*memFile{{}, {}}
+	// Each file holds up to two packets.
+	const payloadSize = 200
+	fileSize := int64(len(sectionHeaderBlock()) + len(interfaceDescriptionBlock("eth0", DefaultSnapLen)) + 2*(44+header.IPv4MinimumSize+payloadSize))
+	c, err := Start([]*Endpoint{ep}, Options{
+		Files:    []File{files[0], files[1]},
+		FileSize: fileSize,
+	})
+	if err != nil {
+		t.Fatalf("Start(...): %s", err)
+	}
+	for i := 0; i < 5; i++ {
+		v := ipv4Packet(header.UDPProtocolNumber, payloadSize)
+		// Identify the packet with its IP ID.
+		v[5] = byte(i)
+		lower.InjectInbound(header.IPv4ProtocolNumber, newPacket(v))
+	}
+	if n, err := c.Stop(); err != nil || n != 5 {
+		t.Fatalf("got Stop() = (%d, %v), want = (5, nil)", n, err)
+	}
+
+	// The fifth packet overwrote the first file.
+	for i, want := range [][]byte{{4}, {2, 3}} {
+		_, packets := parse(t, files[i])
+		var ids []byte
+		for _, p := range packets {
+			ids = append(ids, p.Data[5])
+		}
+		if !bytes.Equal(ids, want) {
+			t.Errorf("got packets %v in file %d, want = %v", ids, i, want)
+		}
+		if size := int64(len(files[i].b)); size > fileSize {
+			t.Errorf("got file %d size = %d, want <= %d", i, size, fileSize)
+		}
+	}
+}
+
+func TestParseFilter(t *testing.T) {
+	for _, s := range []string{
+		// Output of tcpdump -ddd.
+		"4\n48 0 0 9\n21 0 1 17\n6 0 0 30\n6 0 0 0\n",
+		"4,48 0 0 9,21 0 1 17,6 0 0 30,6 0 0 0",
+	} {
+		got, err := ParseFilter(s)
+		if err != nil {
+			t.Fatalf("ParseFilter(%q): %s", s, err)
+		}
+		if diff := cmp.Diff(udpFilter, got); diff != "" {
+			t.Errorf("ParseFilter(%q) mismatch (-want +got):\n%s", s, diff)
+		}
+	}
+
+	for _, s := range []string{
+		"",
+		"2\n6 0 0 0\n",
+		"1\n6 0 0\n",
+		"1\n6 0 256 0\n",
+		"x\n6 0 0 0\n",
+	} {
+		if _, err := ParseFilter(s); err == nil {
+			t.Errorf("got ParseFilter(%q) = nil error, want error", s)
+		}
+	}
+}
@@ -0,0 +1,147 @@
+// Copyright 2021 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package capture
+
+import (
+	"encoding/binary"
+	"time"
+)
+
+// Block types, as specified in the pcapng specification.
+const (
+	blockTypeSectionHeader      = 0x0a0d0d0a
+	blockTypeInterfaceDesc      = 0x00000001
+	blockTypeEnhancedPacket     = 0x00000006
+	sectionHeaderByteOrderMagic = 0x1a2b3c4d
+)
+
+// Option codes, as specified in the pcapng specification.
+const (
+	optEndOfOpt  = 0
+	optIfName    = 2
+	optEPBFlags  = 2
+	optShbUserAp = 4
+)
+
+// Directions of packets held in epb_flags.
+const (
+	epbFlagsInbound  = 1
+	epbFlagsOutbound = 2
+)
+
+// linkTypeRaw is the link type of interfaces whose packets start with their
+// IPv4 or IPv6 header.
+const linkTypeRaw = 101
+
+// byteOrder is the byte order of the blocks. Readers detect it with the byte
+// order magic of the section header block.
+var byteOrder = binary.LittleEndian
+
+// pad4 returns n rounded up to a multiple of 4.
+func pad4(n int) int {
+	return (n + 3) &^ 3
+}
+
+// blockBuilder builds a pcapng block.
+type blockBuilder struct {
+	b []byte
+}
+
+func newBlock(blockType uint32) *blockBuilder {
+	bb := &blockBuilder{}
+	bb.putUint32(blockType)
+	// The total length is set by finish.
+	bb.putUint32(0)
+	return bb
+}
+
+func (bb *blockBuilder) putUint16(v uint16) {
+	bb.b = append(bb.b, 0, 0)
+	byteOrder.PutUint16(bb.b[len(bb.b)-2:], v)
+}
+
+func (bb *blockBuilder) putUint32(v uint32) {
+	bb.b = append(bb.b, 0, 0, 0, 0)
+	byteOrder.PutUint32(bb.b[len(bb.b)-4:], v)
+}
+
+func (bb *blockBuilder) putUint64(v uint64) {
+	bb.b = append(bb.b, 0, 0, 0, 0, 0, 0, 0, 0)
+	byteOrder.PutUint64(bb.b[len(bb.b)-8:], v)
+}
+
+// putPadded appends b followed by zeros up to a multiple of 4 bytes.
+func (bb *blockBuilder) putPadded(b []byte) {
+	bb.b = append(bb.b, b...)
+	for i := len(b); i < pad4(len(b)); i++ {
+		bb.b = append(bb.b, 0)
+	}
+}
+
+func (bb *blockBuilder) putOption(code uint16, value []byte) {
+	bb.putUint16(code)
+	bb.putUint16(uint16(len(value)))
+	bb.putPadded(value)
+}
+
+// finish terminates the options of the block and returns it.
+func (bb *blockBuilder) finish() []byte {
+	bb.putOption(optEndOfOpt, nil)
+	total := uint32(len(bb.b) + 4)
+	bb.putUint32(total)
+	byteOrder.PutUint32(bb.b[4:], total)
+	return bb.b
+}
+
+// sectionHeaderBlock returns a section header block of unspecified length.
+func sectionHeaderBlock() []byte {
+	bb := newBlock(blockTypeSectionHeader)
+	bb.putUint32(sectionHeaderByteOrderMagic)
+	bb.putUint16(1) // Major version.
+	bb.putUint16(0) // Minor version.
+	bb.putUint64(^uint64(0))
+	bb.putOption(optShbUserAp, []byte("gVisor"))
+	return bb.finish()
+}
+
+// interfaceDescriptionBlock returns an interface description block for
+// interface name, whose timestamps are in microseconds.
+func interfaceDescriptionBlock(name string, snapLen uint32) []byte {
+	bb := newBlock(blockTypeInterfaceDesc)
+	bb.putUint16(linkTypeRaw)
+	bb.putUint16(0) // Reserved.
+	bb.putUint32(snapLen)
+	if name != "" {
+		bb.putOption(optIfName, []byte(name))
+	}
+	return bb.finish()
+}
+
+// enhancedPacketBlock returns an enhanced packet block holding data, the
+// first bytes of a packet of length origLen.
+func enhancedPacketBlock(ifIndex uint32, ts time.Time, flags uint32, data []byte, origLen int) []byte {
+	bb := newBlock(blockTypeEnhancedPacket)
+	bb.putUint32(ifIndex)
+	us := uint64(ts.UnixNano() / int64(time.Microsecond))
+	bb.putUint32(uint32(us >> 32))
+	bb.putUint32(uint32(us))
+	bb.putUint32(uint32(len(data)))
+	bb.putUint32(uint32(origLen))
+	bb.putPadded(data)
+	var flagsBuf [4]byte
+	byteOrder.PutUint32(flagsBuf[:], flags)
+	bb.putOption(optEPBFlags, flagsBuf[:])
+	return bb.finish()
+}
@@ -103,6 +103,11 @@ type endpoint struct {
 	// hash outbound packets to specific channels based on the packet hash.
 	fds []int
 
+	// socketFDs indicates, for each fd in fds, whether it is a socket.
+	// Packets are written in batches with sendmmsg(2) to sockets, and one at
+	// a time with writev(2) to other fds, e.g. tap devices.
+	socketFDs []bool
+
 	// mtu (maximum transmission unit) is the maximum size of a packet.
 	mtu uint32
 
@@ -155,6 +160,13 @@ type Options struct {
 	// EthernetHeader is true.
 	Address tcpip.LinkAddress
 
+	// ResolutionDisabled if true, indicates that the link addresses of
+	// neighbors are not resolved and outbound frames are sent with an
+	// unspecified destination address. This is used with devices that do
+	// not forward ARP and ignore the destination of outbound frames, such as
+	// ipvlan devices in L3 mode. Only used if EthernetHeader is true.
+	ResolutionDisabled bool
+
 	// SaveRestore if true, indicates that this NIC capability set should
 	// include CapabilitySaveRestore
 	SaveRestore bool
@@ -209,7 +221,9 @@ func New(opts *Options) (stack.LinkEndpoint, error) {
 	hdrSize := 0
 	if opts.EthernetHeader {
 		hdrSize = header.EthernetMinimumSize
-		caps |= stack.CapabilityResolutionRequired
+		if !opts.ResolutionDisabled {
+			caps |= stack.CapabilityResolutionRequired
+		}
 	}
 
 	if opts.SaveRestore {
@@ -245,13 +259,17 @@ func New(opts *Options) (stack.LinkEndpoint, error) {
 		if err != nil {
 			return nil, err
 		}
-		if isSocket {
-			if opts.GSOMaxSize != 0 {
-				if opts.SoftwareGSOEnabled {
-					e.caps |= stack.CapabilitySoftwareGSO
-				} else {
-					e.caps |= stack.CapabilityHardwareGSO
-				}
+		e.socketFDs = append(e.socketFDs, isSocket)
+		if opts.GSOMaxSize != 0 {
+			// Software GSO only relies on batched writes, which
+			// are supported by all fds, but the virtio net header
+			// used by hardware GSO is only enabled by runsc for
+			// AF_PACKET sockets.
+			if opts.SoftwareGSOEnabled {
+				e.caps |= stack.CapabilitySoftwareGSO
+				e.gsoMaxSize = opts.GSOMaxSize
+			} else if isSocket {
+				e.caps |= stack.CapabilityHardwareGSO
 				e.gsoMaxSize = opts.GSOMaxSize
 			}
 		}
@@ -451,8 +469,20 @@ func (e *endpoint) WritePacket(r stack.RouteInfo, gso *stack.GSO, protocol tcpip
 	return rawfile.NonBlockingWriteIovec(fd, builder.Build())
 }
 
-func (e *endpoint) sendBatch(batchFD int, batch []*stack.PacketBuffer) (int, *tcpip.Error) {
+func (e *endpoint) sendBatch(batchFDIdx int, batch []*stack.PacketBuffer) (int, *tcpip.Error) {
 	// Send a batch of packets through batchFD.
+	batchFD := e.fds[batchFDIdx]
+	if !e.socketFDs[batchFDIdx] {
+		// sendmmsg(2) is only supported by sockets, write the packets
+		// one at a time. WritePacket picks the same fd as all packets in
+		// the batch have the same hash.
+		for i, pkt := range batch {
+			if err := e.WritePacket(pkt.EgressRoute, pkt.GSOOptions, pkt.NetworkProtocolNumber, pkt); err != nil {
+				return i, err
+			}
+		}
+		return len(batch), nil
+	}
 	mmsgHdrs := make([]rawfile.MMsgHdr, 0, len(batch))
 	for _, pkt := range batch {
 		if e.hdrSize > 0 {
@@ -525,29 +555,29 @@ func (e *endpoint) WritePackets(_ stack.RouteInfo, _ *stack.GSO, pkts stack.Pack
 	// byte segment.
 	const batchSz = 47
 	batch := make([]*stack.PacketBuffer, 0, batchSz)
-	batchFD := -1
+	batchFDIdx := -1
 	sentPackets := 0
 	for pkt := pkts.Front(); pkt != nil; pkt = pkt.Next() {
 		if len(batch) == 0 {
-			batchFD = e.fds[pkt.Hash%uint32(len(e.fds))]
+			batchFDIdx = int(pkt.Hash % uint32(len(e.fds)))
 		}
-		pktFD := e.fds[pkt.Hash%uint32(len(e.fds))]
-		if sendNow := pktFD != batchFD; !sendNow {
+		pktFDIdx := int(pkt.Hash % uint32(len(e.fds)))
+		if sendNow := pktFDIdx != batchFDIdx; !sendNow {
 			batch = append(batch, pkt)
 			continue
 		}
-		n, err := e.sendBatch(batchFD, batch)
+		n, err := e.sendBatch(batchFDIdx, batch)
 		sentPackets += n
 		if err != nil {
 			return sentPackets, err
 		}
 		batch = batch[:0]
 		batch = append(batch, pkt)
-		batchFD = pktFD
+		batchFDIdx = pktFDIdx
 	}
 
 	if len(batch) != 0 {
-		n, err := e.sendBatch(batchFD, batch)
+		n, err := e.sendBatch(batchFDIdx, batch)
 		sentPackets += n
 		if err != nil {
 			return sentPackets, err
@@ -316,6 +316,70 @@ func TestHashedWritePacket(t *testing.T) {
 	}
 }
 
+func TestResolutionDisabled(t *testing.T) {
+	for _, disabled := range []bool{false, true} {
+		t.Run(fmt.Sprintf("ResolutionDisabled=%t", disabled), func(t *testing.T) {
+			c := newContext(t, &Options{EthernetHeader: true, ResolutionDisabled: disabled, MTU: mtu})
+			defer c.cleanup()
+
+			if got, want := c.ep.Capabilities()&stack.CapabilityResolutionRequired != 0, !disabled; got != want {
+				t.Fatalf("got Capabilities()&CapabilityResolutionRequired != 0 = %t, want = %t", got, want)
+			}
+		})
+	}
+}
+
+// TestWritePacketsNonSocket tests that batched writes to an fd that isn't a
+// socket, e.g. a tap device, write each packet separately.
+func TestWritePacketsNonSocket(t *testing.T) {
+	var fds [2]int
+	if err := syscall.Pipe(fds[:]); err != nil {
+		t.Fatalf("Pipe failed: %v", err)
+	}
+	defer syscall.Close(fds[0])
+	defer syscall.Close(fds[1])
+
+	ep, err := New(&Options{FDs: []int{fds[1]}, MTU: mtu, EthernetHeader: true, Address: laddr})
+	if err != nil {
+		t.Fatalf("Failed to create FD endpoint: %v", err)
+	}
+
+	var pkts stack.PacketBufferList
+	var want []byte
+	for i := 0; i < 3; i++ {
+		payload := buffer.View(fmt.Sprintf("packet %d", i))
+		pkt := stack.NewPacketBuffer(stack.PacketBufferOptions{
+			ReserveHeaderBytes: int(ep.MaxHeaderLength()),
+			Data:               payload.ToVectorisedView(),
+		})
+		pkt.EgressRoute.RemoteLinkAddress = raddr
+		pkt.NetworkProtocolNumber = proto
+		pkts.PushBack(pkt)
+
+		eth := make([]byte, header.EthernetMinimumSize)
+		header.Ethernet(eth).Encode(&header.EthernetFields{
+			SrcAddr: laddr,
+			DstAddr: raddr,
+			Type:    proto,
+		})
+		want = append(want, eth...)
+		want = append(want, payload...)
+	}
+
+	if n, err := ep.WritePackets(stack.RouteInfo{}, nil /* gso */, pkts, proto); err != nil || n != 3 {
+		t.Fatalf("got WritePackets(...) = (%d, %s), want = (3, nil)", n, err)
+	}
+
+	b := make([]byte, len(want)+1)
+	n, err := syscall.Read(fds[0], b)
+	if err != nil {
+		t.Fatalf("Read failed: %v", err)
+	}
+	if !bytes.Equal(b[:n], want) {
+		t.Fatalf("Read returned %x, want %x", b[:n], want)
+	}
+}
+
 func TestPreserveSrcAddress(t *testing.T) {
 	baddr := tcpip.LinkAddress("\xcc\xbb\xaa\x77\x88\x99")
 
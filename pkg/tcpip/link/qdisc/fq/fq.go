@@ -0,0 +1,470 @@
+// Copyright 2021 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package fq provides flow queueing disciplines, which schedule the packets
+// written to a NIC fairly between flows with deficit round robin:
+//
+//   * Qdisc, as tc-fq(8), can pace each flow to a maximum rate.
+//   * CoDelQdisc, as tc-fq_codel(8), drops the packets of the flows that are
+//     queued for too long with the CoDel algorithm (RFC 8289).
+//
+// Link endpoints do not push back on netstack, so packets are only queued
+// while their flow is paced.
+package fq
+
+import (
+	"math"
+	"math/rand"
+	"time"
+
+	"gvisor.dev/gvisor/pkg/sync"
+	"gvisor.dev/gvisor/pkg/tcpip"
+	"gvisor.dev/gvisor/pkg/tcpip/hash/jenkins"
+	"gvisor.dev/gvisor/pkg/tcpip/header"
+	"gvisor.dev/gvisor/pkg/tcpip/stack"
+)
+
+// Options are the parameters of a fair queue.
+type Options struct {
+	// Limit is the number of packets that can be queued. Packets are dropped
+	// when the queue is full.
+	Limit uint32
+
+	// FlowLimit is the number of packets of each flow that can be queued.
+	FlowLimit uint32
+
+	// Quantum is the number of bytes each flow can release per round.
+	Quantum uint32
+
+	// MaxRate is the maximum rate of each flow, in bytes per second. If zero,
+	// flows are not paced.
+	MaxRate uint64
+}
+
+// CoDelOptions are the parameters of a fair queue with controlled delay.
+type CoDelOptions struct {
+	// Limit is the number of packets that can be queued. Packets are dropped
+	// when the queue is full.
+	Limit uint32
+
+	// Flows is the number of flow queues packets are hashed to.
+	Flows uint32
+
+	// Quantum is the number of bytes each flow can release per round.
+	Quantum uint32
+
+	// Target is the acceptable minimum queueing delay of the packets.
+	Target time.Duration
+
+	// Interval is the period the queueing delay must stay above Target for
+	// packets to be dropped. It should be the worst case round-trip time of
+	// the flows.
+	Interval time.Duration
+}
+
+// Qdisc is a fair queue.
+type Qdisc struct {
+	scheduler
+	opts Options
+}
+
+var _ stack.QueueingDiscipline = (*Qdisc)(nil)
+
+// New returns a function creating a fair queue with opts.
+//
+// Preconditions: opts.Quantum must not be zero.
+func New(opts Options) stack.NewQueueingDisciplineFunc {
+	return func(w stack.QueueingDisciplineWriter, clock tcpip.Clock) stack.QueueingDiscipline {
+		q := &Qdisc{opts: opts}
+		q.init(w, clock, opts.Limit, opts.Quantum)
+		q.flowLimit = opts.FlowLimit
+		q.maxRate = opts.MaxRate
+		return q
+	}
+}
+
+// Options returns the parameters of the queue.
+func (q *Qdisc) Options() Options {
+	return q.opts
+}
+
+// CoDelQdisc is a fair queue with controlled delay.
+type CoDelQdisc struct {
+	scheduler
+	opts CoDelOptions
+}
+
+var _ stack.QueueingDiscipline = (*CoDelQdisc)(nil)
+
+// NewCoDel returns a function creating a fair queue with controlled delay with
+// opts.
+//
+// Preconditions: opts.Quantum, opts.Flows and opts.Interval must not be zero.
+func NewCoDel(opts CoDelOptions) stack.NewQueueingDisciplineFunc {
+	return func(w stack.QueueingDisciplineWriter, clock tcpip.Clock) stack.QueueingDiscipline {
+		q := &CoDelQdisc{opts: opts}
+		q.init(w, clock, opts.Limit, opts.Quantum)
+		q.buckets = opts.Flows
+		q.codel = true
+		q.target = int64(opts.Target)
+		q.interval = int64(opts.Interval)
+		return q
+	}
+}
+
+// Options returns the parameters of the queue.
+func (q *CoDelQdisc) Options() CoDelOptions {
+	return q.opts
+}
+
+// entry is a queued packet.
+type entry struct {
+	pkt *stack.PacketBuffer
+
+	// enqueuedAt is the monotonic time the packet was queued at.
+	enqueuedAt int64
+}
+
+// flow is the queue of the packets of a flow.
+type flow struct {
+	key   uint32
+	queue []entry
+
+	// active is true if the flow is in the round robin list of the
+	// scheduler.
+	active bool
+
+	// deficit is the number of bytes the flow can still release in the
+	// current round.
+	deficit int
+
+	// timeNext is the monotonic time before which a paced flow cannot
+	// release packets.
+	timeNext int64
+
+	// The CoDel state of the flow, see RFC 8289 section 5.
+	firstAboveTime int64
+	dropNext       int64
+	count          uint32
+	lastCount      uint32
+	dropping       bool
+}
+
+// scheduler implements the round robin scheduling of the flows.
+type scheduler struct {
+	w     stack.QueueingDisciplineWriter
+	clock tcpip.Clock
+	seed  uint32
+
+	limit     uint32
+	flowLimit uint32
+	quantum   int
+	maxRate   uint64
+
+	// buckets, if not zero, is the number of flow queues the flows are hashed
+	// to. Otherwise, each flow has its own queue.
+	buckets uint32
+
+	// codel enables CoDel with target and interval, in nanoseconds.
+	codel    bool
+	target   int64
+	interval int64
+
+	mu struct {
+		sync.Mutex
+
+		closed bool
+
+		flows map[uint32]*flow
+
+		// active is the round robin list of the flows with queued packets.
+		active []*flow
+
+		// queued is the number of queued packets.
+		queued uint32
+
+		// timer releases the packets of the paced flows. It is nil until
+		// first needed.
+		timer tcpip.Timer
+		// timerAt is the monotonic time timer fires at, or 0 if it is not
+		// armed.
+		timerAt int64
+	}
+}
+
+func (s *scheduler) init(w stack.QueueingDisciplineWriter, clock tcpip.Clock, limit, quantum uint32) {
+	s.w = w
+	s.clock = clock
+	s.seed = rand.New(rand.NewSource(clock.NowNanoseconds())).Uint32()
+	s.limit = limit
+	s.quantum = int(quantum)
+	s.mu.flows = make(map[uint32]*flow)
+}
+
+// flowKey returns the key of the flow of pkt.
+func (s *scheduler) flowKey(pkt *stack.PacketBuffer) uint32 {
+	hash := pkt.Hash
+	if hash == 0 {
+		h := jenkins.Sum32(s.seed)
+		nh := pkt.NetworkHeader().View()
+		switch pkt.NetworkProtocolNumber {
+		case header.IPv4ProtocolNumber:
+			if len(nh) >= header.IPv4MinimumSize {
+				ip := header.IPv4(nh)
+				h.Write([]byte(ip.SourceAddress()))
+				h.Write([]byte(ip.DestinationAddress()))
+			}
+		case header.IPv6ProtocolNumber:
+			if len(nh) >= header.IPv6MinimumSize {
+				ip := header.IPv6(nh)
+				h.Write([]byte(ip.SourceAddress()))
+				h.Write([]byte(ip.DestinationAddress()))
+			}
+		}
+		h.Write([]byte{uint8(pkt.TransportProtocolNumber)})
+		// The ports of TCP and UDP.
+		if th := pkt.TransportHeader().View(); len(th) >= 4 {
+			h.Write(th[:4])
+		}
+		hash = h.Sum32()
+	}
+	if s.buckets != 0 {
+		return hash % s.buckets
+	}
+	return hash
+}
+
+// WritePacket implements stack.QueueingDiscipline.WritePacket.
+func (s *scheduler) WritePacket(pkt *stack.PacketBuffer) *tcpip.Error {
+	key := s.flowKey(pkt)
+
+	s.mu.Lock()
+	if s.mu.closed {
+		s.mu.Unlock()
+		return tcpip.ErrClosedForSend
+	}
+	f, ok := s.mu.flows[key]
+	if !ok {
+		f = &flow{key: key}
+		s.mu.flows[key] = f
+	}
+	if s.mu.queued >= s.limit || (s.flowLimit != 0 && uint32(len(f.queue)) >= s.flowLimit) {
+		// As in Linux, drops are not reported to the writer.
+		s.mu.Unlock()
+		return nil
+	}
+
+	now := s.clock.NowMonotonic()
+	f.queue = append(f.queue, entry{pkt: pkt, enqueuedAt: now})
+	s.mu.queued++
+	if !f.active {
+		f.active = true
+		f.deficit = s.quantum
+		s.mu.active = append(s.mu.active, f)
+	}
+	pkts := s.releaseLocked(now)
+	s.mu.Unlock()
+
+	s.write(pkts)
+	return nil
+}
+
+// releaseLocked dequeues the packets that can be released at now, and arms
+// the timer to release the packets of the paced flows.
+//
+// Precondition: s.mu must be locked.
+func (s *scheduler) releaseLocked(now int64) []*stack.PacketBuffer {
+	var pkts []*stack.PacketBuffer
+	// wait is the time until the first paced flow can release a packet.
+	var wait int64
+	// Stop once all the active flows are paced.
+	for paced := 0; paced < len(s.mu.active); {
+		f := s.mu.active[0]
+		if f.timeNext > now {
+			if d := f.timeNext - now; wait == 0 || d < wait {
+				wait = d
+			}
+			s.rotateLocked()
+			paced++
+			continue
+		}
+		if f.deficit <= 0 {
+			f.deficit += s.quantum
+			s.rotateLocked()
+			paced = 0
+			continue
+		}
+
+		pkt := s.dequeueLocked(f, now)
+		if pkt == nil {
+			f.active = false
+			s.mu.active[0] = nil
+			s.mu.active = s.mu.active[1:]
+			if s.buckets == 0 {
+				delete(s.mu.flows, f.key)
+			}
+			continue
+		}
+		paced = 0
+		size := pkt.Size()
+		f.deficit -= size
+		if s.maxRate != 0 {
+			f.timeNext = now + int64(uint64(size)*uint64(time.Second)/s.maxRate)
+		}
+		pkts = append(pkts, pkt)
+	}
+
+	if wait != 0 && (s.mu.timerAt == 0 || now+wait < s.mu.timerAt) {
+		if s.mu.timer == nil {
+			s.mu.timer = s.clock.AfterFunc(time.Duration(wait), s.onTimer)
+		} else {
+			s.mu.timer.Stop()
+			s.mu.timer.Reset(time.Duration(wait))
+		}
+		s.mu.timerAt = now + wait
+	}
+	return pkts
+}
+
+// rotateLocked moves the first active flow to the end of the round robin
+// list.
+//
+// Precondition: s.mu must be locked.
+func (s *scheduler) rotateLocked() {
+	f := s.mu.active[0]
+	copy(s.mu.active, s.mu.active[1:])
+	s.mu.active[len(s.mu.active)-1] = f
+}
+
+// popLocked removes the first packet of f.
+//
+// Precondition: s.mu must be locked.
+func (s *scheduler) popLocked(f *flow) (entry, bool) {
+	if len(f.queue) == 0 {
+		return entry{}, false
+	}
+	e := f.queue[0]
+	f.queue[0] = entry{}
+	f.queue = f.queue[1:]
+	s.mu.queued--
+	return e, true
+}
+
+// dequeueLocked removes the next packet of f to release, or returns nil if
+// the queue of f is empty.
+//
+// If CoDel is enabled, packets that stayed in the queue for too long are
+// dropped as in the pseudocode of RFC 8289 section 5.
+//
+// Precondition: s.mu must be locked.
+func (s *scheduler) dequeueLocked(f *flow, now int64) *stack.PacketBuffer {
+	e, ok := s.popLocked(f)
+	if !s.codel {
+		return e.pkt
+	}
+	if !ok {
+		f.dropping = false
+		return nil
+	}
+
+	okToDrop := s.okToDrop(f, e, now)
+	if f.dropping {
+		if !okToDrop {
+			f.dropping = false
+		}
+		for f.dropping && now >= f.dropNext {
+			// Drop the packet.
+			f.count++
+			if e, ok = s.popLocked(f); !ok {
+				f.dropping = false
+				return nil
+			}
+			if s.okToDrop(f, e, now) {
+				f.dropNext = s.controlLaw(f.dropNext, f.count)
+			} else {
+				f.dropping = false
+			}
+		}
+	} else if okToDrop {
+		// Drop the packet.
+		if e, ok = s.popLocked(f); !ok {
+			return nil
+		}
+		f.dropping = true
+		delta := f.count - f.lastCount
+		f.count = 1
+		if delta > 1 && now-f.dropNext < 16*s.interval {
+			f.count = delta
+		}
+		f.dropNext = s.controlLaw(now, f.count)
+		f.lastCount = f.count
+	}
+	return e.pkt
+}
+
+// okToDrop returns true if e, dequeued from f, has been queued for longer than
+// the target delay for at least an interval.
+func (s *scheduler) okToDrop(f *flow, e entry, now int64) bool {
+	if now-e.enqueuedAt < s.target || len(f.queue) == 0 {
+		f.firstAboveTime = 0
+		return false
+	}
+	if f.firstAboveTime == 0 {
+		f.firstAboveTime = now + s.interval
+		return false
+	}
+	return now >= f.firstAboveTime
+}
+
+// controlLaw returns the time of the next drop after the count-th drop at t.
+func (s *scheduler) controlLaw(t int64, count uint32) int64 {
+	return t + int64(float64(s.interval)/math.Sqrt(float64(count)))
+}
+
+func (s *scheduler) onTimer() {
+	s.mu.Lock()
+	if s.mu.closed {
+		s.mu.Unlock()
+		return
+	}
+	s.mu.timerAt = 0
+	pkts := s.releaseLocked(s.clock.NowMonotonic())
+	s.mu.Unlock()
+
+	s.write(pkts)
+}
+
+// write writes the released pkts.
+//
+// It must be called without holding s.mu, since writing a packet may write
+// more packets to the same NIC, e.g. on loopback. Errors are ignored since the
+// packets are not necessarily released by their writers.
+func (s *scheduler) write(pkts []*stack.PacketBuffer) {
+	for _, pkt := range pkts {
+		_ = s.w.WriteQueuedPacket(pkt)
+	}
+}
+
+// Close implements stack.QueueingDiscipline.Close.
+func (s *scheduler) Close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.mu.closed = true
+	s.mu.flows = nil
+	s.mu.active = nil
+	s.mu.queued = 0
+	if s.mu.timer != nil {
+		s.mu.timer.Stop()
+	}
+}
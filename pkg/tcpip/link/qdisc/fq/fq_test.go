@@ -0,0 +1,143 @@
+// Copyright 2021 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fq_test
+
+import (
+	"testing"
+	"time"
+
+	"gvisor.dev/gvisor/pkg/sync"
+	"gvisor.dev/gvisor/pkg/tcpip"
+	"gvisor.dev/gvisor/pkg/tcpip/buffer"
+	"gvisor.dev/gvisor/pkg/tcpip/faketime"
+	"gvisor.dev/gvisor/pkg/tcpip/link/qdisc/fq"
+	"gvisor.dev/gvisor/pkg/tcpip/stack"
+)
+
+// writer records the packets released by a qdisc.
+type writer struct {
+	mu   sync.Mutex
+	pkts []*stack.PacketBuffer
+}
+
+// WriteQueuedPacket implements stack.QueueingDisciplineWriter.
+func (w *writer) WriteQueuedPacket(pkt *stack.PacketBuffer) *tcpip.Error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.pkts = append(w.pkts, pkt)
+	return nil
+}
+
+func (w *writer) written() int {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return len(w.pkts)
+}
+
+func newPacket(size int) *stack.PacketBuffer {
+	return stack.NewPacketBuffer(stack.PacketBufferOptions{
+		Data: buffer.NewView(size).ToVectorisedView(),
+	})
+}
+
+func newFlowPacket(hash uint32, size int) *stack.PacketBuffer {
+	pkt := newPacket(size)
+	pkt.Hash = hash
+	return pkt
+}
+
+func TestPacing(t *testing.T) {
+	const pktSize = 100
+
+	clock := faketime.NewManualClock()
+	var w writer
+	q := fq.New(fq.Options{
+		Limit:     100,
+		FlowLimit: 10,
+		Quantum:   2 * pktSize,
+		MaxRate:   1000,
+	})(&w, clock)
+	defer q.Close()
+
+	// Each flow is paced independently.
+	for _, hash := range []uint32{1, 1, 1, 2} {
+		if err := q.WritePacket(newFlowPacket(hash, pktSize)); err != nil {
+			t.Fatalf("q.WritePacket(_): %s", err)
+		}
+	}
+	if got, want := w.written(), 2; got != want {
+		t.Fatalf("got w.written() = %d, want = %d", got, want)
+	}
+
+	clock.Advance(99 * time.Millisecond)
+	if got, want := w.written(), 2; got != want {
+		t.Fatalf("got w.written() = %d after 99ms, want = %d", got, want)
+	}
+	clock.Advance(time.Millisecond)
+	if got, want := w.written(), 3; got != want {
+		t.Fatalf("got w.written() = %d after 100ms, want = %d", got, want)
+	}
+	clock.Advance(100 * time.Millisecond)
+	if got, want := w.written(), 4; got != want {
+		t.Fatalf("got w.written() = %d after 200ms, want = %d", got, want)
+	}
+}
+
+func TestFlowLimit(t *testing.T) {
+	clock := faketime.NewManualClock()
+	var w writer
+	q := fq.New(fq.Options{
+		Limit:     100,
+		FlowLimit: 2,
+		Quantum:   1000,
+		MaxRate:   1000,
+	})(&w, clock)
+	defer q.Close()
+
+	// The first packet is released, the next two are queued and the last one
+	// is dropped.
+	for i := 0; i < 4; i++ {
+		if err := q.WritePacket(newFlowPacket(1, 100)); err != nil {
+			t.Fatalf("q.WritePacket(_): %s", err)
+		}
+	}
+	clock.Advance(time.Second)
+	if got, want := w.written(), 3; got != want {
+		t.Fatalf("got w.written() = %d, want = %d", got, want)
+	}
+}
+
+func TestCoDelReleasesPackets(t *testing.T) {
+	clock := faketime.NewManualClock()
+	var w writer
+	q := fq.NewCoDel(fq.CoDelOptions{
+		Limit:    10,
+		Flows:    16,
+		Quantum:  1000,
+		Target:   5 * time.Millisecond,
+		Interval: 100 * time.Millisecond,
+	})(&w, clock)
+	defer q.Close()
+
+	// Packets are not delayed, so they are never dropped.
+	for i := 0; i < 20; i++ {
+		if err := q.WritePacket(newFlowPacket(uint32(i%3)+1, 100)); err != nil {
+			t.Fatalf("q.WritePacket(_): %s", err)
+		}
+	}
+	if got, want := w.written(), 20; got != want {
+		t.Fatalf("got w.written() = %d, want = %d", got, want)
+	}
+}
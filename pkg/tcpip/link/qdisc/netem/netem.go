@@ -0,0 +1,226 @@
+// Copyright 2021 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package netem provides a network emulator queueing discipline, which delays,
+// drops and duplicates the packets written to a NIC as tc-netem(8) does.
+package netem
+
+import (
+	"math"
+	"math/rand"
+	"sort"
+	"time"
+
+	"gvisor.dev/gvisor/pkg/sync"
+	"gvisor.dev/gvisor/pkg/tcpip"
+	"gvisor.dev/gvisor/pkg/tcpip/stack"
+)
+
+// Options are the parameters of a network emulator.
+type Options struct {
+	// Latency is the delay added to the packets.
+	Latency time.Duration
+
+	// Jitter is the maximum random variation of the delay of each packet,
+	// which is uniformly distributed in [Latency-Jitter, Latency+Jitter].
+	// Packets may be reordered as a result.
+	Jitter time.Duration
+
+	// Loss is the probability of dropping a packet, scaled to
+	// [0, math.MaxUint32].
+	Loss uint32
+
+	// Duplicate is the probability of duplicating a packet, scaled to
+	// [0, math.MaxUint32].
+	Duplicate uint32
+
+	// Limit is the number of packets that can be queued. Packets are dropped
+	// when the queue is full.
+	Limit uint32
+}
+
+// packet is a delayed packet.
+type packet struct {
+	pkt *stack.PacketBuffer
+
+	// sendAt is the monotonic time the packet is released at.
+	sendAt int64
+}
+
+// Qdisc is a network emulator.
+type Qdisc struct {
+	w     stack.QueueingDisciplineWriter
+	clock tcpip.Clock
+	opts  Options
+
+	mu struct {
+		sync.Mutex
+
+		closed bool
+		rng    *rand.Rand
+
+		// queue holds the delayed packets, in the order they are released.
+		queue []packet
+
+		// timer releases the first packet of queue. It is nil until first
+		// needed.
+		timer tcpip.Timer
+		// timerAt is the monotonic time timer fires at, or 0 if it is not
+		// armed.
+		timerAt int64
+	}
+}
+
+var _ stack.QueueingDiscipline = (*Qdisc)(nil)
+
+// New returns a function creating a network emulator with opts.
+func New(opts Options) stack.NewQueueingDisciplineFunc {
+	return func(w stack.QueueingDisciplineWriter, clock tcpip.Clock) stack.QueueingDiscipline {
+		q := &Qdisc{
+			w:     w,
+			clock: clock,
+			opts:  opts,
+		}
+		q.mu.rng = rand.New(rand.NewSource(clock.NowNanoseconds()))
+		return q
+	}
+}
+
+// Options returns the parameters of the emulator.
+func (q *Qdisc) Options() Options {
+	return q.opts
+}
+
+// chanceLocked returns true with probability p scaled to [0, math.MaxUint32].
+//
+// Precondition: q.mu must be locked.
+func (q *Qdisc) chanceLocked(p uint32) bool {
+	return p != 0 && (p == math.MaxUint32 || q.mu.rng.Uint32() < p)
+}
+
+// delayLocked returns the delay of a packet.
+//
+// Precondition: q.mu must be locked.
+func (q *Qdisc) delayLocked() time.Duration {
+	d := q.opts.Latency
+	if q.opts.Jitter != 0 {
+		d += time.Duration(q.mu.rng.Int63n(int64(2*q.opts.Jitter)+1)) - q.opts.Jitter
+	}
+	if d < 0 {
+		d = 0
+	}
+	return d
+}
+
+// WritePacket implements stack.QueueingDiscipline.WritePacket.
+func (q *Qdisc) WritePacket(pkt *stack.PacketBuffer) *tcpip.Error {
+	q.mu.Lock()
+	if q.mu.closed {
+		q.mu.Unlock()
+		return tcpip.ErrClosedForSend
+	}
+
+	// As in Linux, drops are not reported to the writer.
+	if q.chanceLocked(q.opts.Loss) {
+		q.mu.Unlock()
+		return nil
+	}
+	pkts := []*stack.PacketBuffer{pkt}
+	if q.chanceLocked(q.opts.Duplicate) {
+		pkts = append(pkts, pkt.Clone())
+	}
+
+	now := q.clock.NowMonotonic()
+	for _, pkt := range pkts {
+		if uint32(len(q.mu.queue)) >= q.opts.Limit {
+			break
+		}
+		sendAt := now + int64(q.delayLocked())
+		// Keep the queue sorted by release time, preserving the order of the
+		// packets released at the same time.
+		i := sort.Search(len(q.mu.queue), func(i int) bool {
+			return q.mu.queue[i].sendAt > sendAt
+		})
+		q.mu.queue = append(q.mu.queue, packet{})
+		copy(q.mu.queue[i+1:], q.mu.queue[i:])
+		q.mu.queue[i] = packet{pkt: pkt, sendAt: sendAt}
+	}
+	released := q.releaseLocked(now)
+	q.mu.Unlock()
+
+	q.write(released)
+	return nil
+}
+
+// releaseLocked dequeues the packets due at now, and arms the timer to release
+// the next packet.
+//
+// Precondition: q.mu must be locked.
+func (q *Qdisc) releaseLocked(now int64) []*stack.PacketBuffer {
+	var pkts []*stack.PacketBuffer
+	for len(q.mu.queue) != 0 && q.mu.queue[0].sendAt <= now {
+		pkts = append(pkts, q.mu.queue[0].pkt)
+		q.mu.queue[0] = packet{}
+		q.mu.queue = q.mu.queue[1:]
+	}
+
+	if len(q.mu.queue) != 0 {
+		next := q.mu.queue[0].sendAt
+		if q.mu.timerAt == 0 || next < q.mu.timerAt {
+			if q.mu.timer == nil {
+				q.mu.timer = q.clock.AfterFunc(time.Duration(next-now), q.onTimer)
+			} else {
+				q.mu.timer.Stop()
+				q.mu.timer.Reset(time.Duration(next - now))
+			}
+			q.mu.timerAt = next
+		}
+	}
+	return pkts
+}
+
+func (q *Qdisc) onTimer() {
+	q.mu.Lock()
+	if q.mu.closed {
+		q.mu.Unlock()
+		return
+	}
+	q.mu.timerAt = 0
+	pkts := q.releaseLocked(q.clock.NowMonotonic())
+	q.mu.Unlock()
+
+	q.write(pkts)
+}
+
+// write writes the released pkts.
+//
+// It must be called without holding q.mu, since writing a packet may write
+// more packets to the same NIC, e.g. on loopback. Errors are ignored since the
+// packets are not necessarily released by their writers.
+func (q *Qdisc) write(pkts []*stack.PacketBuffer) {
+	for _, pkt := range pkts {
+		_ = q.w.WriteQueuedPacket(pkt)
+	}
+}
+
+// Close implements stack.QueueingDiscipline.Close.
+func (q *Qdisc) Close() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.mu.closed = true
+	q.mu.queue = nil
+	if q.mu.timer != nil {
+		q.mu.timer.Stop()
+	}
+}
@@ -0,0 +1,132 @@
+// Copyright 2021 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package netem_test
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"gvisor.dev/gvisor/pkg/sync"
+	"gvisor.dev/gvisor/pkg/tcpip"
+	"gvisor.dev/gvisor/pkg/tcpip/buffer"
+	"gvisor.dev/gvisor/pkg/tcpip/faketime"
+	"gvisor.dev/gvisor/pkg/tcpip/link/qdisc/netem"
+	"gvisor.dev/gvisor/pkg/tcpip/stack"
+)
+
+// writer records the packets released by a qdisc.
+type writer struct {
+	mu   sync.Mutex
+	pkts []*stack.PacketBuffer
+}
+
+// WriteQueuedPacket implements stack.QueueingDisciplineWriter.
+func (w *writer) WriteQueuedPacket(pkt *stack.PacketBuffer) *tcpip.Error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.pkts = append(w.pkts, pkt)
+	return nil
+}
+
+func (w *writer) written() int {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return len(w.pkts)
+}
+
+func newPacket(size int) *stack.PacketBuffer {
+	return stack.NewPacketBuffer(stack.PacketBufferOptions{
+		Data: buffer.NewView(size).ToVectorisedView(),
+	})
+}
+
+func TestNetem(t *testing.T) {
+	const latency = 100 * time.Millisecond
+
+	tests := []struct {
+		name      string
+		opts      netem.Options
+		writes    int
+		wantDelay time.Duration
+		want      int
+	}{
+		{
+			name:   "No delay",
+			opts:   netem.Options{Limit: 10},
+			writes: 2,
+			want:   2,
+		},
+		{
+			name:      "Delay",
+			opts:      netem.Options{Latency: latency, Limit: 10},
+			writes:    2,
+			wantDelay: latency,
+			want:      2,
+		},
+		{
+			name:      "Jitter",
+			opts:      netem.Options{Latency: latency, Jitter: latency / 2, Limit: 10},
+			writes:    5,
+			wantDelay: latency / 2,
+			want:      5,
+		},
+		{
+			name:      "Limit",
+			opts:      netem.Options{Latency: latency, Limit: 3},
+			writes:    5,
+			wantDelay: latency,
+			want:      3,
+		},
+		{
+			name:   "Loss",
+			opts:   netem.Options{Loss: math.MaxUint32, Limit: 10},
+			writes: 2,
+			want:   0,
+		},
+		{
+			name:   "Duplicate",
+			opts:   netem.Options{Duplicate: math.MaxUint32, Limit: 10},
+			writes: 2,
+			want:   4,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			clock := faketime.NewManualClock()
+			var w writer
+			q := netem.New(test.opts)(&w, clock)
+			defer q.Close()
+
+			for i := 0; i < test.writes; i++ {
+				if err := q.WritePacket(newPacket(100)); err != nil {
+					t.Fatalf("q.WritePacket(_): %s", err)
+				}
+			}
+
+			if test.wantDelay != 0 {
+				clock.Advance(test.wantDelay - 1)
+				if got := w.written(); got != 0 {
+					t.Fatalf("got w.written() = %d before the delay, want = 0", got)
+				}
+			}
+			clock.Advance(2 * latency)
+			if got := w.written(); got != test.want {
+				t.Fatalf("got w.written() = %d, want = %d", got, test.want)
+			}
+		})
+	}
+}
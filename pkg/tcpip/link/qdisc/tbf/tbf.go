@@ -0,0 +1,200 @@
+// Copyright 2021 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package tbf provides a token bucket filter queueing discipline, which shapes
+// the packets written to a NIC to a configured rate as tc-tbf(8) does.
+package tbf
+
+import (
+	"time"
+
+	"gvisor.dev/gvisor/pkg/sync"
+	"gvisor.dev/gvisor/pkg/tcpip"
+	"gvisor.dev/gvisor/pkg/tcpip/stack"
+)
+
+// Options are the parameters of a token bucket filter.
+type Options struct {
+	// Rate is the rate packets are released at, in bytes per second.
+	Rate uint64
+
+	// Burst is the size of the bucket, in bytes: the number of bytes that can
+	// be released at once after the filter has been idle. Larger packets are
+	// dropped.
+	Burst uint32
+
+	// Limit is the number of bytes of the packets that can be queued waiting
+	// for tokens. Packets are dropped when the queue is full.
+	Limit uint32
+}
+
+// Qdisc is a token bucket filter.
+//
+// As in Linux, tokens are accounted as the time needed to release bytes at the
+// rate of the filter.
+type Qdisc struct {
+	w     stack.QueueingDisciplineWriter
+	clock tcpip.Clock
+	opts  Options
+
+	// burst is the time needed to release Burst bytes, i.e. the size of the
+	// bucket.
+	burst time.Duration
+
+	mu struct {
+		sync.Mutex
+
+		closed bool
+
+		// queue holds the packets waiting for tokens.
+		queue []*stack.PacketBuffer
+
+		// queued is the number of bytes in queue.
+		queued uint32
+
+		// tokens is the number of tokens in the bucket as of lastRefill.
+		tokens     time.Duration
+		lastRefill int64
+
+		// timer releases the packets of queue once there are enough tokens.
+		// It is nil until first needed.
+		timer      tcpip.Timer
+		timerArmed bool
+	}
+}
+
+var _ stack.QueueingDiscipline = (*Qdisc)(nil)
+
+// New returns a function creating a token bucket filter with opts.
+//
+// Preconditions: opts.Rate and opts.Burst must not be zero.
+func New(opts Options) stack.NewQueueingDisciplineFunc {
+	return func(w stack.QueueingDisciplineWriter, clock tcpip.Clock) stack.QueueingDiscipline {
+		q := &Qdisc{
+			w:     w,
+			clock: clock,
+			opts:  opts,
+			burst: txTime(uint64(opts.Burst), opts.Rate),
+		}
+		q.mu.tokens = q.burst
+		q.mu.lastRefill = clock.NowMonotonic()
+		return q
+	}
+}
+
+// txTime returns the time needed to release n bytes at rate bytes per second.
+func txTime(n, rate uint64) time.Duration {
+	return time.Duration(n * uint64(time.Second) / rate)
+}
+
+// Options returns the parameters of the filter.
+func (q *Qdisc) Options() Options {
+	return q.opts
+}
+
+// WritePacket implements stack.QueueingDiscipline.WritePacket.
+func (q *Qdisc) WritePacket(pkt *stack.PacketBuffer) *tcpip.Error {
+	size := uint32(pkt.Size())
+
+	q.mu.Lock()
+	if q.mu.closed {
+		q.mu.Unlock()
+		return tcpip.ErrClosedForSend
+	}
+	if size > q.opts.Burst || q.mu.queued+size > q.opts.Limit {
+		// As in Linux, drops are not reported to the writer.
+		q.mu.Unlock()
+		return nil
+	}
+	q.mu.queue = append(q.mu.queue, pkt)
+	q.mu.queued += size
+	pkts := q.releaseLocked()
+	q.mu.Unlock()
+
+	q.write(pkts)
+	return nil
+}
+
+// releaseLocked dequeues the packets there are enough tokens for, and arms the
+// timer to release the next packet otherwise.
+//
+// Precondition: q.mu must be locked.
+func (q *Qdisc) releaseLocked() []*stack.PacketBuffer {
+	now := q.clock.NowMonotonic()
+	q.mu.tokens += time.Duration(now - q.mu.lastRefill)
+	if q.mu.tokens > q.burst {
+		q.mu.tokens = q.burst
+	}
+	q.mu.lastRefill = now
+
+	var pkts []*stack.PacketBuffer
+	for len(q.mu.queue) != 0 {
+		pkt := q.mu.queue[0]
+		size := pkt.Size()
+		cost := txTime(uint64(size), q.opts.Rate)
+		if cost > q.mu.tokens {
+			if !q.mu.timerArmed {
+				q.mu.timerArmed = true
+				if q.mu.timer == nil {
+					q.mu.timer = q.clock.AfterFunc(cost-q.mu.tokens, q.onTimer)
+				} else {
+					q.mu.timer.Reset(cost - q.mu.tokens)
+				}
+			}
+			break
+		}
+		q.mu.tokens -= cost
+		q.mu.queue[0] = nil
+		q.mu.queue = q.mu.queue[1:]
+		q.mu.queued -= uint32(size)
+		pkts = append(pkts, pkt)
+	}
+	return pkts
+}
+
+func (q *Qdisc) onTimer() {
+	q.mu.Lock()
+	if q.mu.closed {
+		q.mu.Unlock()
+		return
+	}
+	q.mu.timerArmed = false
+	pkts := q.releaseLocked()
+	q.mu.Unlock()
+
+	q.write(pkts)
+}
+
+// write writes the released pkts.
+//
+// It must be called without holding q.mu, since writing a packet may write
+// more packets to the same NIC, e.g. on loopback. Errors are ignored since the
+// packets are not necessarily released by their writers.
+func (q *Qdisc) write(pkts []*stack.PacketBuffer) {
+	for _, pkt := range pkts {
+		_ = q.w.WriteQueuedPacket(pkt)
+	}
+}
+
+// Close implements stack.QueueingDiscipline.Close.
+func (q *Qdisc) Close() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.mu.closed = true
+	q.mu.queue = nil
+	q.mu.queued = 0
+	if q.mu.timer != nil {
+		q.mu.timer.Stop()
+	}
+}
@@ -0,0 +1,150 @@
+// Copyright 2021 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tbf_test
+
+import (
+	"testing"
+	"time"
+
+	"gvisor.dev/gvisor/pkg/sync"
+	"gvisor.dev/gvisor/pkg/tcpip"
+	"gvisor.dev/gvisor/pkg/tcpip/buffer"
+	"gvisor.dev/gvisor/pkg/tcpip/faketime"
+	"gvisor.dev/gvisor/pkg/tcpip/link/qdisc/tbf"
+	"gvisor.dev/gvisor/pkg/tcpip/stack"
+)
+
+// writer records the packets released by a qdisc.
+type writer struct {
+	mu   sync.Mutex
+	pkts []*stack.PacketBuffer
+}
+
+// WriteQueuedPacket implements stack.QueueingDisciplineWriter.
+func (w *writer) WriteQueuedPacket(pkt *stack.PacketBuffer) *tcpip.Error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.pkts = append(w.pkts, pkt)
+	return nil
+}
+
+func (w *writer) written() int {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return len(w.pkts)
+}
+
+func newPacket(size int) *stack.PacketBuffer {
+	return stack.NewPacketBuffer(stack.PacketBufferOptions{
+		Data: buffer.NewView(size).ToVectorisedView(),
+	})
+}
+
+func TestRate(t *testing.T) {
+	const pktSize = 500
+
+	clock := faketime.NewManualClock()
+	var w writer
+	q := tbf.New(tbf.Options{
+		Rate:  1000,
+		Burst: 2 * pktSize,
+		Limit: 10 * pktSize,
+	})(&w, clock)
+	defer q.Close()
+
+	for i := 0; i < 4; i++ {
+		if err := q.WritePacket(newPacket(pktSize)); err != nil {
+			t.Fatalf("q.WritePacket(_): %s", err)
+		}
+	}
+
+	// The bucket is full, so the first two packets are released at once.
+	if got, want := w.written(), 2; got != want {
+		t.Fatalf("got w.written() = %d, want = %d", got, want)
+	}
+
+	// The other ones are released at the rate of the filter.
+	clock.Advance(499 * time.Millisecond)
+	if got, want := w.written(), 2; got != want {
+		t.Fatalf("got w.written() = %d after 499ms, want = %d", got, want)
+	}
+	clock.Advance(time.Millisecond)
+	if got, want := w.written(), 3; got != want {
+		t.Fatalf("got w.written() = %d after 500ms, want = %d", got, want)
+	}
+	clock.Advance(500 * time.Millisecond)
+	if got, want := w.written(), 4; got != want {
+		t.Fatalf("got w.written() = %d after 1s, want = %d", got, want)
+	}
+}
+
+func TestDrops(t *testing.T) {
+	const (
+		burst = 1000
+		limit = 1500
+	)
+
+	clock := faketime.NewManualClock()
+	var w writer
+	q := tbf.New(tbf.Options{
+		Rate:  1000,
+		Burst: burst,
+		Limit: limit,
+	})(&w, clock)
+	defer q.Close()
+
+	// Packets larger than the bucket can never be released.
+	if err := q.WritePacket(newPacket(burst + 1)); err != nil {
+		t.Fatalf("q.WritePacket(_): %s", err)
+	}
+	if got := w.written(); got != 0 {
+		t.Fatalf("got w.written() = %d, want = 0", got)
+	}
+
+	// Empty the bucket and fill the queue.
+	for _, size := range []int{burst, limit, 1} {
+		if err := q.WritePacket(newPacket(size - 1)); err != nil {
+			t.Fatalf("q.WritePacket(_): %s", err)
+		}
+	}
+	clock.Advance(10 * time.Second)
+	if got, want := w.written(), 2; got != want {
+		t.Fatalf("got w.written() = %d, want = %d", got, want)
+	}
+}
+
+func TestClose(t *testing.T) {
+	clock := faketime.NewManualClock()
+	var w writer
+	q := tbf.New(tbf.Options{
+		Rate:  1000,
+		Burst: 1000,
+		Limit: 1000,
+	})(&w, clock)
+
+	for i := 0; i < 2; i++ {
+		if err := q.WritePacket(newPacket(1000)); err != nil {
+			t.Fatalf("q.WritePacket(_): %s", err)
+		}
+	}
+	q.Close()
+	clock.Advance(time.Second)
+	if got, want := w.written(), 1; got != want {
+		t.Fatalf("got w.written() = %d, want = %d", got, want)
+	}
+	if err := q.WritePacket(newPacket(1)); err != tcpip.ErrClosedForSend {
+		t.Fatalf("got q.WritePacket(_) = %s, want = %s", err, tcpip.ErrClosedForSend)
+	}
+}
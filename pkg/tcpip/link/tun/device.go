@@ -15,6 +15,7 @@
 package tun
 
 import (
+	"encoding/binary"
 	"fmt"
 
 	"gvisor.dev/gvisor/pkg/abi/linux"
@@ -36,6 +37,15 @@ const (
 	// Queue length for outbound packet, arriving at fd side for read. Overflow
 	// causes packet drops. gVisor implementation-specific.
 	defaultDevOutQueueLen = 1024
+
+	// drivers/net/tun.c:MAX_TAP_QUEUES
+	maxQueues = 256
+
+	// Flags accepted by TUNSETIFF.
+	supportedFlags = linux.IFF_TUN | linux.IFF_TAP | linux.IFF_NO_PI | linux.IFF_ONE_QUEUE | linux.IFF_VNET_HDR | linux.IFF_MULTI_QUEUE
+
+	// Offloads accepted by TUNSETOFFLOAD.
+	supportedOffloads = linux.TUN_F_CSUM | linux.TUN_F_TSO4 | linux.TUN_F_TSO6 | linux.TUN_F_TSO_ECN | linux.TUN_F_UFO
 )
 
 var zeroMAC [6]byte
@@ -50,6 +60,18 @@ type Device struct {
 	endpoint     *tunEndpoint
 	notifyHandle *channel.NotificationHandle
 	flags        uint16
+
+	// detached is true if this queue was detached from endpoint with
+	// TUNSETQUEUE. A detached queue keeps its reference on endpoint so that
+	// it can be attached again, but it neither sends nor receives packets.
+	detached bool
+
+	// vnetHdrSize is the size of the virtio header prepended to every packet
+	// if IFF_VNET_HDR is set.
+	vnetHdrSize int
+
+	// offloads is the set of TUN_F_* offloads configured with TUNSETOFFLOAD.
+	offloads uint32
 }
 
 // beforeSave is invoked by stateify.
@@ -70,7 +92,10 @@ func (d *Device) Release(ctx context.Context) {
 
 	// Decrease refcount if there is an endpoint associated with this file.
 	if d.endpoint != nil {
-		d.endpoint.RemoveNotify(d.notifyHandle)
+		if !d.detached {
+			d.endpoint.RemoveNotify(d.notifyHandle)
+			d.endpoint.removeQueue()
+		}
 		d.endpoint.DecRef(ctx)
 		d.endpoint = nil
 	}
@@ -88,10 +113,12 @@ func (d *Device) SetIff(s *stack.Stack, name string, flags uint16) error {
 	// Input validations.
 	isTun := flags&linux.IFF_TUN != 0
 	isTap := flags&linux.IFF_TAP != 0
-	supportedFlags := uint16(linux.IFF_TUN | linux.IFF_TAP | linux.IFF_NO_PI)
 	if isTap && isTun || !isTap && !isTun || flags&^supportedFlags != 0 {
 		return syserror.EINVAL
 	}
+	// IFF_ONE_QUEUE is ignored by Linux and is not reported back.
+	flags &^= linux.IFF_ONE_QUEUE
+	multiQueue := flags&linux.IFF_MULTI_QUEUE != 0
 
 	prefix := "tun"
 	if isTap {
@@ -103,18 +130,19 @@ func (d *Device) SetIff(s *stack.Stack, name string, flags uint16) error {
 		linkCaps |= stack.CapabilityResolutionRequired
 	}
 
-	endpoint, err := attachOrCreateNIC(s, name, prefix, linkCaps)
+	endpoint, err := attachOrCreateNIC(s, name, prefix, linkCaps, multiQueue)
 	if err != nil {
-		return syserror.EINVAL
+		return err
 	}
 
 	d.endpoint = endpoint
 	d.notifyHandle = d.endpoint.AddNotify(d)
 	d.flags = flags
+	d.vnetHdrSize = VirtioNetHeaderMinSize
 	return nil
 }
 
-func attachOrCreateNIC(s *stack.Stack, name, prefix string, linkCaps stack.LinkEndpointCapabilities) (*tunEndpoint, error) {
+func attachOrCreateNIC(s *stack.Stack, name, prefix string, linkCaps stack.LinkEndpointCapabilities, multiQueue bool) (*tunEndpoint, error) {
 	for {
 		// 1. Try to attach to an existing NIC.
 		if name != "" {
@@ -124,10 +152,17 @@ func attachOrCreateNIC(s *stack.Stack, name, prefix string, linkCaps stack.LinkE
 					// Not a NIC created by tun device.
 					return nil, syserror.EOPNOTSUPP
 				}
+				if endpoint.isTap != (prefix == "tap") || endpoint.multiQueue != multiQueue {
+					return nil, syserror.EINVAL
+				}
 				if !endpoint.TryIncRef() {
 					// Race detected: NIC got deleted in between.
 					continue
 				}
+				if err := endpoint.addQueue(); err != nil {
+					endpoint.DecRef(context.Background())
+					return nil, err
+				}
 				return endpoint, nil
 			}
 		}
@@ -135,11 +170,13 @@ func attachOrCreateNIC(s *stack.Stack, name, prefix string, linkCaps stack.LinkE
 		// 2. Creating a new NIC.
 		id := tcpip.NICID(s.UniqueID())
 		endpoint := &tunEndpoint{
-			Endpoint: channel.New(defaultDevOutQueueLen, defaultDevMtu, ""),
-			stack:    s,
-			nicID:    id,
-			name:     name,
-			isTap:    prefix == "tap",
+			Endpoint:   channel.New(defaultDevOutQueueLen, defaultDevMtu, ""),
+			stack:      s,
+			nicID:      id,
+			name:       name,
+			isTap:      prefix == "tap",
+			multiQueue: multiQueue,
+			numQueues:  1,
 		}
 		endpoint.InitRefs()
 		endpoint.Endpoint.LinkEPCapabilities = linkCaps
@@ -161,12 +198,117 @@ func attachOrCreateNIC(s *stack.Stack, name, prefix string, linkCaps stack.LinkE
 	}
 }
 
+// SetPersist services TUNSETPERSIST ioctl(2) request.
+//
+// A persistent device outlives the files attached to it: the NIC is only
+// removed once persistence is cleared and the last queue is closed.
+func (d *Device) SetPersist(ctx context.Context, persist bool) error {
+	d.mu.RLock()
+	endpoint := d.endpoint
+	detached := d.detached
+	d.mu.RUnlock()
+	if endpoint == nil || detached {
+		return syserror.EBADFD
+	}
+
+	endpoint.mu.Lock()
+	changed := endpoint.persistent != persist
+	endpoint.persistent = persist
+	endpoint.mu.Unlock()
+	if !changed {
+		return nil
+	}
+	// The persistent flag holds its own reference on the endpoint.
+	if persist {
+		endpoint.IncRef()
+	} else {
+		endpoint.DecRef(ctx)
+	}
+	return nil
+}
+
+// SetQueue services TUNSETQUEUE ioctl(2) request.
+func (d *Device) SetQueue(flags uint16) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	switch {
+	case flags&linux.IFF_ATTACH_QUEUE != 0:
+		if d.endpoint == nil || !d.detached {
+			return syserror.EINVAL
+		}
+		if err := d.endpoint.addQueue(); err != nil {
+			return err
+		}
+		d.notifyHandle = d.endpoint.AddNotify(d)
+		d.detached = false
+		return nil
+
+	case flags&linux.IFF_DETACH_QUEUE != 0:
+		if d.endpoint == nil || !d.endpoint.multiQueue || d.detached {
+			return syserror.EINVAL
+		}
+		d.endpoint.RemoveNotify(d.notifyHandle)
+		d.endpoint.removeQueue()
+		d.notifyHandle = nil
+		d.detached = true
+		return nil
+
+	default:
+		return syserror.EINVAL
+	}
+}
+
+// SetVnetHdrSize services TUNSETVNETHDRSZ ioctl(2) request.
+func (d *Device) SetVnetHdrSize(size int) error {
+	if size < VirtioNetHeaderMinSize {
+		return syserror.EINVAL
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.vnetHdrSize = size
+	return nil
+}
+
+// VnetHdrSize services TUNGETVNETHDRSZ ioctl(2) request.
+func (d *Device) VnetHdrSize() int {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	if d.vnetHdrSize == 0 {
+		return VirtioNetHeaderMinSize
+	}
+	return d.vnetHdrSize
+}
+
+// SetOffload services TUNSETOFFLOAD ioctl(2) request.
+//
+// Packets sent to the fd side are never segmentation offloaded, so the
+// negotiated offloads only affect which virtio headers are accepted on write.
+func (d *Device) SetOffload(offloads uint32) error {
+	if offloads&^supportedOffloads != 0 {
+		return syserror.EINVAL
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.endpoint == nil || d.detached {
+		return syserror.EBADFD
+	}
+	d.offloads = offloads
+	return nil
+}
+
+// Features services TUNGETFEATURES ioctl(2) request.
+func Features() uint32 {
+	return supportedFlags
+}
+
 // Write inject one inbound packet to the network interface.
 func (d *Device) Write(data []byte) (int64, error) {
 	d.mu.RLock()
 	endpoint := d.endpoint
+	detached := d.detached
 	d.mu.RUnlock()
-	if endpoint == nil {
+	if endpoint == nil || detached {
 		return 0, syserror.EBADFD
 	}
 	if !endpoint.IsAttached() {
@@ -186,6 +328,19 @@ func (d *Device) Write(data []byte) (int64, error) {
 		data = data[PacketInfoHeaderSize:]
 	}
 
+	// Virtio header.
+	if d.hasFlags(linux.IFF_VNET_HDR) {
+		vnetHdrSize := d.VnetHdrSize()
+		if len(data) < vnetHdrSize {
+			return 0, syserror.EINVAL
+		}
+		vnetHdr := VirtioNetHeader(data[:VirtioNetHeaderMinSize])
+		data = data[vnetHdrSize:]
+		if err := d.applyVirtioHeader(vnetHdr, data); err != nil {
+			return 0, err
+		}
+	}
+
 	// Ethernet header (TAP only).
 	var ethHdr header.Ethernet
 	if d.hasFlags(linux.IFF_TAP) {
@@ -224,12 +379,54 @@ func (d *Device) Write(data []byte) (int64, error) {
 	return dataLen, nil
 }
 
+// applyVirtioHeader performs the work requested by the virtio header hdr on
+// the frame data, which is modified in place.
+func (d *Device) applyVirtioHeader(hdr VirtioNetHeader, data []byte) error {
+	d.mu.RLock()
+	offloads := d.offloads
+	d.mu.RUnlock()
+
+	switch hdr.GSOType() &^ VirtioNetHeaderGSOECN {
+	case VirtioNetHeaderGSONone:
+	case VirtioNetHeaderGSOTCPv4:
+		if offloads&linux.TUN_F_TSO4 == 0 {
+			return syserror.EINVAL
+		}
+	case VirtioNetHeaderGSOTCPv6:
+		if offloads&linux.TUN_F_TSO6 == 0 {
+			return syserror.EINVAL
+		}
+	case VirtioNetHeaderGSOUDP:
+		if offloads&linux.TUN_F_UFO == 0 {
+			return syserror.EINVAL
+		}
+	default:
+		return syserror.EINVAL
+	}
+	// Oversized segments are delivered to the stack as is, which accepts
+	// inbound packets larger than the MTU, so there is nothing to segment.
+
+	if hdr.Flags()&VirtioNetHeaderFlagNeedsCSum != 0 {
+		// The checksum field already holds the pseudo-header checksum; the
+		// sender expects us to fold in everything from csum_start onwards.
+		start := int(hdr.CSumStart())
+		off := start + int(hdr.CSumOffset())
+		if start > len(data) || off+2 > len(data) {
+			return syserror.EINVAL
+		}
+		xsum := header.Checksum(data[start:], 0)
+		binary.BigEndian.PutUint16(data[off:], ^xsum)
+	}
+	return nil
+}
+
 // Read reads one outgoing packet from the network interface.
 func (d *Device) Read() ([]byte, error) {
 	d.mu.RLock()
 	endpoint := d.endpoint
+	detached := d.detached
 	d.mu.RUnlock()
-	if endpoint == nil {
+	if endpoint == nil || detached {
 		return nil, syserror.EBADFD
 	}
 
@@ -261,6 +458,16 @@ func (d *Device) encodePkt(info *channel.PacketInfo) (buffer.View, bool) {
 		vv.AppendView(buffer.View(hdr))
 	}
 
+	// Virtio header. Outbound packets are fully checksummed and never
+	// segmentation offloaded, so an all-zero header describes them.
+	if d.hasFlags(linux.IFF_VNET_HDR) {
+		hdr := make(VirtioNetHeader, d.VnetHdrSize())
+		hdr.Encode(&VirtioNetHeaderFields{
+			GSOType: VirtioNetHeaderGSONone,
+		})
+		vv.AppendView(buffer.View(hdr))
+	}
+
 	// If the packet does not already have link layer header, and the route
 	// does not exist, we can't compute it. This is possibly a raw packet, tun
 	// device doesn't support this at the moment.
@@ -301,7 +508,11 @@ func (d *Device) Name() string {
 func (d *Device) Flags() uint16 {
 	d.mu.RLock()
 	defer d.mu.RUnlock()
-	return d.flags
+	flags := d.flags
+	if d.endpoint != nil && d.endpoint.isPersistent() {
+		flags |= linux.IFF_PERSIST
+	}
+	return flags
 }
 
 func (d *Device) hasFlags(flags uint16) bool {
@@ -313,8 +524,9 @@ func (d *Device) Readiness(mask waiter.EventMask) waiter.EventMask {
 	if mask&waiter.EventIn != 0 {
 		d.mu.RLock()
 		endpoint := d.endpoint
+		detached := d.detached
 		d.mu.RUnlock()
-		if endpoint != nil && endpoint.NumQueued() == 0 {
+		if endpoint != nil && (detached || endpoint.NumQueued() == 0) {
 			mask &= ^waiter.EventIn
 		}
 	}
@@ -330,14 +542,54 @@ func (d *Device) WriteNotify() {
 //
 // It is ref-counted as multiple opening files can attach to the same NIC.
 // The last owner is responsible for deleting the NIC.
+//
+// Every queue of a multi-queue device reads from the same outbound channel,
+// so packets are spread across the queues that are ready to read them.
 type tunEndpoint struct {
 	tunEndpointRefs
 	*channel.Endpoint
 
-	stack *stack.Stack
-	nicID tcpip.NICID
-	name  string
-	isTap bool
+	stack      *stack.Stack
+	nicID      tcpip.NICID
+	name       string
+	isTap      bool
+	multiQueue bool
+
+	mu sync.Mutex
+
+	// numQueues is the number of attached (not detached) queues.
+	numQueues int
+
+	// persistent is set with TUNSETPERSIST. When true, the endpoint holds
+	// an extra reference on itself.
+	persistent bool
+}
+
+// addQueue accounts for a newly attached queue.
+func (e *tunEndpoint) addQueue() error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if !e.multiQueue && e.numQueues > 0 {
+		return syserror.EBUSY
+	}
+	if e.numQueues >= maxQueues {
+		return syserror.E2BIG
+	}
+	e.numQueues++
+	return nil
+}
+
+// removeQueue accounts for a detached or released queue.
+func (e *tunEndpoint) removeQueue() {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.numQueues--
+}
+
+func (e *tunEndpoint) isPersistent() bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.persistent
 }
 
 // DecRef decrements refcount of e, removing NIC if it reaches 0.
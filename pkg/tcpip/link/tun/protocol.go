@@ -54,3 +54,81 @@ func (h PacketInfoHeader) Flags() uint16 {
 func (h PacketInfoHeader) Protocol() tcpip.NetworkProtocolNumber {
 	return tcpip.NetworkProtocolNumber(binary.BigEndian.Uint16(h[offsetProtocol:]))
 }
+
+const (
+	// VirtioNetHeaderMinSize is the size of struct virtio_net_hdr, which is
+	// the smallest virtio header that can be configured with
+	// TUNSETVNETHDRSZ.
+	VirtioNetHeaderMinSize = 10
+
+	offsetVirtioFlags      = 0
+	offsetVirtioGSOType    = 1
+	offsetVirtioHdrLen     = 2
+	offsetVirtioGSOSize    = 4
+	offsetVirtioCSumStart  = 6
+	offsetVirtioCSumOffset = 8
+)
+
+// Values of the flags field of struct virtio_net_hdr, from
+// include/uapi/linux/virtio_net.h.
+const (
+	VirtioNetHeaderFlagNeedsCSum = 1
+	VirtioNetHeaderFlagDataValid = 2
+)
+
+// Values of the gso_type field of struct virtio_net_hdr, from
+// include/uapi/linux/virtio_net.h.
+const (
+	VirtioNetHeaderGSONone  = 0
+	VirtioNetHeaderGSOTCPv4 = 1
+	VirtioNetHeaderGSOUDP   = 3
+	VirtioNetHeaderGSOTCPv6 = 4
+	VirtioNetHeaderGSOECN   = 0x80
+)
+
+// VirtioNetHeaderFields contains the fields of the virtio header sent through
+// the wire if the IFF_VNET_HDR flag is set.
+type VirtioNetHeaderFields struct {
+	Flags      uint8
+	GSOType    uint8
+	HdrLen     uint16
+	GSOSize    uint16
+	CSumStart  uint16
+	CSumOffset uint16
+}
+
+// VirtioNetHeader is the wire representation of struct virtio_net_hdr.
+//
+// The multi-byte fields are in the guest's native byte order, which is little
+// endian on all supported architectures.
+type VirtioNetHeader []byte
+
+// Encode encodes f into h.
+func (h VirtioNetHeader) Encode(f *VirtioNetHeaderFields) {
+	h[offsetVirtioFlags] = f.Flags
+	h[offsetVirtioGSOType] = f.GSOType
+	binary.LittleEndian.PutUint16(h[offsetVirtioHdrLen:][:2], f.HdrLen)
+	binary.LittleEndian.PutUint16(h[offsetVirtioGSOSize:][:2], f.GSOSize)
+	binary.LittleEndian.PutUint16(h[offsetVirtioCSumStart:][:2], f.CSumStart)
+	binary.LittleEndian.PutUint16(h[offsetVirtioCSumOffset:][:2], f.CSumOffset)
+}
+
+// Flags returns the flags field in h.
+func (h VirtioNetHeader) Flags() uint8 {
+	return h[offsetVirtioFlags]
+}
+
+// GSOType returns the gso_type field in h.
+func (h VirtioNetHeader) GSOType() uint8 {
+	return h[offsetVirtioGSOType]
+}
+
+// CSumStart returns the csum_start field in h.
+func (h VirtioNetHeader) CSumStart() uint16 {
+	return binary.LittleEndian.Uint16(h[offsetVirtioCSumStart:])
+}
+
+// CSumOffset returns the csum_offset field in h.
+func (h VirtioNetHeader) CSumOffset() uint16 {
+	return binary.LittleEndian.Uint16(h[offsetVirtioCSumOffset:])
+}
@@ -0,0 +1,521 @@
+// Copyright 2021 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package tunnel provides the implementation of VXLAN and GENEVE tunnel
+// devices.
+//
+// A tunnel endpoint is an Ethernet link endpoint whose frames are
+// encapsulated in UDP datagrams sent and received through the stack that owns
+// it, over the interfaces that lead to the remote tunnel endpoints.
+package tunnel
+
+import (
+	"bytes"
+	"fmt"
+
+	"gvisor.dev/gvisor/pkg/sync"
+	"gvisor.dev/gvisor/pkg/tcpip"
+	"gvisor.dev/gvisor/pkg/tcpip/buffer"
+	"gvisor.dev/gvisor/pkg/tcpip/header"
+	"gvisor.dev/gvisor/pkg/tcpip/stack"
+	"gvisor.dev/gvisor/pkg/waiter"
+)
+
+// ethernetMTU is the MTU of Ethernet links, which the encapsulated packets of
+// tunnels with the default MTU fit.
+const ethernetMTU = 1500
+
+// Kind is the encapsulation used by a tunnel.
+type Kind int
+
+const (
+	// VXLAN encapsulates frames as described in RFC 7348.
+	VXLAN Kind = iota
+
+	// GENEVE encapsulates frames as described in RFC 8926.
+	GENEVE
+)
+
+// String implements fmt.Stringer.
+func (k Kind) String() string {
+	switch k {
+	case VXLAN:
+		return "vxlan"
+	case GENEVE:
+		return "geneve"
+	default:
+		return fmt.Sprintf("tunnel kind %d", int(k))
+	}
+}
+
+// headerSize returns the size of the headers added to encapsulated frames.
+func (k Kind) headerSize() int {
+	if k == GENEVE {
+		return header.GENEVEMinimumSize
+	}
+	return header.VXLANMinimumSize
+}
+
+// defaultPort returns the UDP port assigned to the encapsulation.
+func (k Kind) defaultPort() uint16 {
+	if k == GENEVE {
+		return header.GENEVEPort
+	}
+	return header.VXLANPort
+}
+
+// Options specify the details about the tunnel endpoint to be created.
+type Options struct {
+	// Kind is the encapsulation used by the tunnel.
+	Kind Kind
+
+	// VNI is the network identifier of the tunnel.
+	VNI uint32
+
+	// Remote is the address of the remote tunnel endpoint that frames are
+	// sent to when their destination was not learnt. If empty, such frames
+	// are dropped.
+	Remote tcpip.Address
+
+	// Local is the source address of encapsulated packets. If empty, it is
+	// chosen by the stack.
+	Local tcpip.Address
+
+	// Port is the UDP port of the tunnel. If zero, the port assigned to Kind
+	// is used.
+	Port uint16
+
+	// NIC is the NIC encapsulated packets are sent and received through. If
+	// zero, packets are routed through any NIC.
+	NIC tcpip.NICID
+
+	// LinkAddress is the Ethernet address of the tunnel device. If empty, a
+	// random address is generated.
+	LinkAddress tcpip.LinkAddress
+
+	// MTU is the MTU of the tunnel device. If zero, it is chosen so that
+	// encapsulated packets fit an Ethernet MTU.
+	MTU uint32
+
+	// Learning enables learning the remote tunnel endpoints of the Ethernet
+	// addresses seen in received frames.
+	Learning bool
+}
+
+// Endpoint is a tunnel link endpoint.
+type Endpoint struct {
+	kind     Kind
+	vni      uint32
+	remote   tcpip.Address
+	local    tcpip.Address
+	port     uint16
+	nic      tcpip.NICID
+	linkAddr tcpip.LinkAddress
+	mtu      uint32
+	learning bool
+
+	// ep is the UDP endpoint encapsulated packets are sent and received
+	// through.
+	ep tcpip.Endpoint
+	wq waiter.Queue
+
+	// wg is used to wait for the goroutine that reads packets from ep to
+	// stop.
+	wg sync.WaitGroup
+
+	mu sync.RWMutex
+	// dispatcher is the dispatcher packets are delivered to. It is protected
+	// by mu.
+	dispatcher stack.NetworkDispatcher
+	// closed is closed when the endpoint is detached from its NIC. It is
+	// protected by mu.
+	closed chan struct{}
+	// fdb maps Ethernet addresses to the remote tunnel endpoints frames are
+	// sent to. It is protected by mu.
+	fdb map[tcpip.LinkAddress]tcpip.Address
+}
+
+var _ stack.LinkEndpoint = (*Endpoint)(nil)
+
+// New creates a tunnel endpoint whose encapsulated packets are sent and
+// received through s.
+func New(s *stack.Stack, opts Options) (*Endpoint, *tcpip.Error) {
+	if opts.VNI > header.MaxVNI {
+		return nil, tcpip.ErrInvalidOptionValue
+	}
+	if len(opts.Remote) != 0 && len(opts.Local) != 0 && len(opts.Remote) != len(opts.Local) {
+		return nil, tcpip.ErrInvalidOptionValue
+	}
+
+	netProto := header.IPv4ProtocolNumber
+	ipHdrSize := header.IPv4MinimumSize
+	if len(opts.Remote) == header.IPv6AddressSize || len(opts.Local) == header.IPv6AddressSize {
+		netProto = header.IPv6ProtocolNumber
+		ipHdrSize = header.IPv6MinimumSize
+	}
+
+	e := &Endpoint{
+		kind:     opts.Kind,
+		vni:      opts.VNI,
+		remote:   opts.Remote,
+		local:    opts.Local,
+		port:     opts.Port,
+		nic:      opts.NIC,
+		linkAddr: opts.LinkAddress,
+		mtu:      opts.MTU,
+		learning: opts.Learning,
+		fdb:      make(map[tcpip.LinkAddress]tcpip.Address),
+	}
+	if e.port == 0 {
+		e.port = e.kind.defaultPort()
+	}
+	if e.linkAddr == "" {
+		e.linkAddr = randomLinkAddress(s)
+	}
+	if e.mtu == 0 {
+		e.mtu = uint32(ethernetMTU - ipHdrSize - header.UDPMinimumSize - e.kind.headerSize() - header.EthernetMinimumSize)
+	}
+
+	ep, err := s.NewEndpoint(header.UDPProtocolNumber, netProto, &e.wq)
+	if err != nil {
+		return nil, err
+	}
+	if opts.NIC != 0 {
+		if err := ep.SocketOptions().SetBindToDevice(int32(opts.NIC)); err != nil {
+			ep.Close()
+			return nil, err
+		}
+	}
+	if err := ep.Bind(tcpip.FullAddress{NIC: opts.NIC, Addr: opts.Local, Port: e.port}); err != nil {
+		ep.Close()
+		return nil, err
+	}
+	if header.IsV4MulticastAddress(opts.Remote) || header.IsV6MulticastAddress(opts.Remote) {
+		// Frames of unknown destinations are flooded to the members of the
+		// group, which the tunnel joins to receive their frames.
+		if err := ep.SetSockOpt(&tcpip.AddMembershipOption{NIC: opts.NIC, MulticastAddr: opts.Remote}); err != nil {
+			ep.Close()
+			return nil, err
+		}
+	}
+	e.ep = ep
+	return e, nil
+}
+
+// randomLinkAddress returns a random locally administered unicast Ethernet
+// address.
+func randomLinkAddress(s *stack.Stack) tcpip.LinkAddress {
+	b := make([]byte, header.EthernetAddressSize)
+	s.Rand().Read(b)
+	b[0] = (b[0] &^ 0x01) | 0x02
+	return tcpip.LinkAddress(b)
+}
+
+// Kind returns the encapsulation used by the tunnel.
+func (e *Endpoint) Kind() Kind {
+	return e.kind
+}
+
+// VNI returns the network identifier of the tunnel.
+func (e *Endpoint) VNI() uint32 {
+	return e.vni
+}
+
+// Remote returns the address of the default remote tunnel endpoint.
+func (e *Endpoint) Remote() tcpip.Address {
+	return e.remote
+}
+
+// Local returns the source address of encapsulated packets, if set.
+func (e *Endpoint) Local() tcpip.Address {
+	return e.local
+}
+
+// NIC returns the NIC encapsulated packets are sent through, if set.
+func (e *Endpoint) NIC() tcpip.NICID {
+	return e.nic
+}
+
+// Port returns the UDP port of the tunnel.
+func (e *Endpoint) Port() uint16 {
+	return e.port
+}
+
+// Learning returns true if the remote tunnel endpoints of received frames are
+// learnt.
+func (e *Endpoint) Learning() bool {
+	return e.learning
+}
+
+// AddRemote makes frames destined to linkAddr be sent to the remote tunnel
+// endpoint addr.
+func (e *Endpoint) AddRemote(linkAddr tcpip.LinkAddress, addr tcpip.Address) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.fdb[linkAddr] = addr
+}
+
+// RemoveRemote removes the remote tunnel endpoint of linkAddr.
+func (e *Endpoint) RemoveRemote(linkAddr tcpip.LinkAddress) *tcpip.Error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if _, ok := e.fdb[linkAddr]; !ok {
+		return tcpip.ErrBadAddress
+	}
+	delete(e.fdb, linkAddr)
+	return nil
+}
+
+// Remotes returns the remote tunnel endpoints of Ethernet addresses.
+func (e *Endpoint) Remotes() map[tcpip.LinkAddress]tcpip.Address {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	remotes := make(map[tcpip.LinkAddress]tcpip.Address, len(e.fdb))
+	for linkAddr, addr := range e.fdb {
+		remotes[linkAddr] = addr
+	}
+	return remotes
+}
+
+// remoteFor returns the remote tunnel endpoint frames destined to linkAddr
+// are sent to.
+func (e *Endpoint) remoteFor(linkAddr tcpip.LinkAddress) tcpip.Address {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	if addr, ok := e.fdb[linkAddr]; ok {
+		return addr
+	}
+	return e.remote
+}
+
+// Attach implements stack.LinkEndpoint.Attach. Attaching a dispatcher starts
+// reading encapsulated packets, and detaching it closes the endpoint.
+func (e *Endpoint) Attach(dispatcher stack.NetworkDispatcher) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	switch {
+	case dispatcher == nil && e.dispatcher != nil:
+		close(e.closed)
+	case dispatcher != nil && e.dispatcher == nil:
+		// Link endpoints are not savable. When transportation endpoints are
+		// saved, they stop sending outgoing packets and all incoming packets
+		// are rejected.
+		e.closed = make(chan struct{})
+		e.wg.Add(1)
+		go func(closed <-chan struct{}) { // S/R-SAFE: See above.
+			defer e.wg.Done()
+			e.dispatchLoop(closed)
+		}(e.closed)
+	}
+	e.dispatcher = dispatcher
+}
+
+// IsAttached implements stack.LinkEndpoint.IsAttached.
+func (e *Endpoint) IsAttached() bool {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	return e.dispatcher != nil
+}
+
+// Wait implements stack.LinkEndpoint.Wait. It waits for the endpoint to stop
+// reading encapsulated packets.
+func (e *Endpoint) Wait() {
+	e.wg.Wait()
+}
+
+// Close releases the resources of an endpoint that could not be attached to
+// a NIC. Endpoints attached to a NIC are released when the NIC is removed.
+func (e *Endpoint) Close() {
+	e.ep.Close()
+}
+
+// MTU implements stack.LinkEndpoint.MTU.
+func (e *Endpoint) MTU() uint32 {
+	return e.mtu
+}
+
+// Capabilities implements stack.LinkEndpoint.Capabilities.
+func (*Endpoint) Capabilities() stack.LinkEndpointCapabilities {
+	return stack.CapabilityResolutionRequired
+}
+
+// MaxHeaderLength implements stack.LinkEndpoint.MaxHeaderLength.
+func (*Endpoint) MaxHeaderLength() uint16 {
+	return header.EthernetMinimumSize
+}
+
+// LinkAddress implements stack.LinkEndpoint.LinkAddress.
+func (e *Endpoint) LinkAddress() tcpip.LinkAddress {
+	return e.linkAddr
+}
+
+// ARPHardwareType implements stack.LinkEndpoint.ARPHardwareType.
+func (*Endpoint) ARPHardwareType() header.ARPHardwareType {
+	return header.ARPHardwareEther
+}
+
+// AddHeader implements stack.LinkEndpoint.AddHeader.
+func (e *Endpoint) AddHeader(local, remote tcpip.LinkAddress, protocol tcpip.NetworkProtocolNumber, pkt *stack.PacketBuffer) {
+	eth := header.Ethernet(pkt.LinkHeader().Push(header.EthernetMinimumSize))
+	ethHdr := &header.EthernetFields{
+		DstAddr: remote,
+		Type:    protocol,
+	}
+
+	// Preserve the src address if it's set in the route.
+	if local != "" {
+		ethHdr.SrcAddr = local
+	} else {
+		ethHdr.SrcAddr = e.linkAddr
+	}
+	eth.Encode(ethHdr)
+}
+
+// WritePacket implements stack.LinkEndpoint.WritePacket. The frame is sent to
+// the remote tunnel endpoint of its destination, or dropped if there is none.
+func (e *Endpoint) WritePacket(r stack.RouteInfo, _ *stack.GSO, protocol tcpip.NetworkProtocolNumber, pkt *stack.PacketBuffer) *tcpip.Error {
+	e.AddHeader(r.LocalLinkAddress, r.RemoteLinkAddress, protocol, pkt)
+
+	remote := e.remoteFor(r.RemoteLinkAddress)
+	if remote == "" {
+		return tcpip.ErrNoRoute
+	}
+
+	hdrSize := e.kind.headerSize()
+	b := make([]byte, hdrSize, hdrSize+pkt.Size())
+	switch e.kind {
+	case VXLAN:
+		header.VXLAN(b).Encode(e.vni)
+	case GENEVE:
+		header.GENEVE(b).Encode(&header.GENEVEFields{
+			Protocol: header.GENEVETransparentEthernetBridging,
+			VNI:      e.vni,
+		})
+	}
+	for _, v := range pkt.Views() {
+		b = append(b, v...)
+	}
+
+	_, err := e.ep.Write(bytes.NewReader(b), tcpip.WriteOptions{
+		To: &tcpip.FullAddress{Addr: remote, Port: e.port},
+	})
+	return err
+}
+
+// WritePackets implements stack.LinkEndpoint.WritePackets.
+func (e *Endpoint) WritePackets(r stack.RouteInfo, gso *stack.GSO, pkts stack.PacketBufferList, protocol tcpip.NetworkProtocolNumber) (int, *tcpip.Error) {
+	n := 0
+	for pkt := pkts.Front(); pkt != nil; pkt = pkt.Next() {
+		if err := e.WritePacket(r, gso, protocol, pkt); err != nil {
+			return n, err
+		}
+		n++
+	}
+	return n, nil
+}
+
+// dispatchLoop reads encapsulated packets and delivers their frames until
+// closed is closed, at which point the UDP endpoint is closed.
+func (e *Endpoint) dispatchLoop(closed <-chan struct{}) {
+	defer e.ep.Close()
+
+	we, ch := waiter.NewChannelEntry(nil)
+	e.wq.EventRegister(&we, waiter.EventIn)
+	defer e.wq.EventUnregister(&we)
+
+	for {
+		var buf bytes.Buffer
+		res, err := e.ep.Read(&buf, tcpip.ReadOptions{NeedRemoteAddr: true})
+		switch err {
+		case nil:
+			e.deliver(buf.Bytes(), res.RemoteAddr.Addr)
+		case tcpip.ErrWouldBlock:
+			select {
+			case <-ch:
+			case <-closed:
+				return
+			}
+		case tcpip.ErrClosedForReceive:
+			return
+		default:
+			// Errors reported by ICMP for previously sent packets are
+			// ignored, as are the packets.
+		}
+	}
+}
+
+// decapsulate returns the frame encapsulated in b, or false if b is not a
+// valid packet of the tunnel.
+func (e *Endpoint) decapsulate(b []byte) ([]byte, bool) {
+	switch e.kind {
+	case VXLAN:
+		if len(b) < header.VXLANMinimumSize {
+			return nil, false
+		}
+		h := header.VXLAN(b)
+		if !h.IsValid() || h.VNI() != e.vni {
+			return nil, false
+		}
+		return b[header.VXLANMinimumSize:], true
+	case GENEVE:
+		if len(b) < header.GENEVEMinimumSize {
+			return nil, false
+		}
+		h := header.GENEVE(b)
+		if h.Version() != header.GENEVEVersion || len(b) < h.HeaderLength() {
+			return nil, false
+		}
+		// Control messages are not supported, and packets with critical
+		// options must be dropped as none are supported. See RFC 8926
+		// section 3.4.
+		if h.OAM() || h.Critical() {
+			return nil, false
+		}
+		if h.Protocol() != header.GENEVETransparentEthernetBridging || h.VNI() != e.vni {
+			return nil, false
+		}
+		return b[h.HeaderLength():], true
+	default:
+		return nil, false
+	}
+}
+
+// deliver delivers the frame encapsulated in the packet b received from the
+// remote tunnel endpoint remote.
+func (e *Endpoint) deliver(b []byte, remote tcpip.Address) {
+	frame, ok := e.decapsulate(b)
+	if !ok || len(frame) < header.EthernetMinimumSize {
+		return
+	}
+	eth := header.Ethernet(frame)
+	src := eth.SourceAddress()
+
+	e.mu.Lock()
+	if e.learning && header.IsValidUnicastEthernetAddress(src) {
+		e.fdb[src] = remote
+	}
+	d := e.dispatcher
+	e.mu.Unlock()
+	if d == nil {
+		return
+	}
+
+	pkt := stack.NewPacketBuffer(stack.PacketBufferOptions{
+		Data: buffer.View(frame).ToVectorisedView(),
+	})
+	if _, ok := pkt.LinkHeader().Consume(header.EthernetMinimumSize); !ok {
+		return
+	}
+	d.DeliverNetworkPacket(src, eth.DestinationAddress(), eth.Type(), pkt)
+}
@@ -0,0 +1,276 @@
+// Copyright 2021 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tunnel_test
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"gvisor.dev/gvisor/pkg/tcpip"
+	"gvisor.dev/gvisor/pkg/tcpip/header"
+	"gvisor.dev/gvisor/pkg/tcpip/link/pipe"
+	"gvisor.dev/gvisor/pkg/tcpip/link/tunnel"
+	"gvisor.dev/gvisor/pkg/tcpip/network/arp"
+	"gvisor.dev/gvisor/pkg/tcpip/network/ipv4"
+	"gvisor.dev/gvisor/pkg/tcpip/stack"
+	"gvisor.dev/gvisor/pkg/tcpip/transport/udp"
+	"gvisor.dev/gvisor/pkg/waiter"
+)
+
+const (
+	underlayNICID = 1
+	tunnelNICID   = 2
+
+	vni  = 42
+	port = 1234
+)
+
+var (
+	underlayAddrs = [2]tcpip.Address{"\x0a\x00\x00\x01", "\x0a\x00\x00\x02"}
+	overlayAddrs  = [2]tcpip.Address{"\xc0\xa8\x00\x01", "\xc0\xa8\x00\x02"}
+)
+
+func addRoute(t *testing.T, s *stack.Stack, addr tcpip.Address, nicID tcpip.NICID) {
+	t.Helper()
+
+	subnet := tcpip.AddressWithPrefix{Address: addr, PrefixLen: 24}.Subnet()
+	s.AddRoute(tcpip.Route{Destination: subnet, NIC: nicID})
+}
+
+// newHosts creates two stacks linked by a pipe, each with a tunnel NIC created
+// with the options returned by opts.
+func newHosts(t *testing.T, opts func(i int) tunnel.Options) ([2]*stack.Stack, [2]*tunnel.Endpoint) {
+	t.Helper()
+
+	var (
+		stacks  [2]*stack.Stack
+		tunnels [2]*tunnel.Endpoint
+	)
+	ep1, ep2 := pipe.New("", "")
+	underlays := [2]stack.LinkEndpoint{ep1, ep2}
+	for i := range stacks {
+		s := stack.New(stack.Options{
+			NetworkProtocols:   []stack.NetworkProtocolFactory{arp.NewProtocol, ipv4.NewProtocol},
+			TransportProtocols: []stack.TransportProtocolFactory{udp.NewProtocol},
+		})
+		if err := s.CreateNIC(underlayNICID, underlays[i]); err != nil {
+			t.Fatalf("s.CreateNIC(%d, _): %s", underlayNICID, err)
+		}
+		if err := s.AddAddress(underlayNICID, ipv4.ProtocolNumber, underlayAddrs[i]); err != nil {
+			t.Fatalf("s.AddAddress(%d, %d, %s): %s", underlayNICID, ipv4.ProtocolNumber, underlayAddrs[i], err)
+		}
+		addRoute(t, s, underlayAddrs[i], underlayNICID)
+
+		tun, err := tunnel.New(s, opts(i))
+		if err != nil {
+			t.Fatalf("tunnel.New(_, %#v): %s", opts(i), err)
+		}
+		if err := s.CreateNIC(tunnelNICID, tun); err != nil {
+			t.Fatalf("s.CreateNIC(%d, _): %s", tunnelNICID, err)
+		}
+		if err := s.AddAddress(tunnelNICID, ipv4.ProtocolNumber, overlayAddrs[i]); err != nil {
+			t.Fatalf("s.AddAddress(%d, %d, %s): %s", tunnelNICID, ipv4.ProtocolNumber, overlayAddrs[i], err)
+		}
+		addRoute(t, s, overlayAddrs[i], tunnelNICID)
+
+		stacks[i] = s
+		tunnels[i] = tun
+	}
+	t.Cleanup(func() {
+		for i, s := range stacks {
+			if err := s.RemoveNIC(tunnelNICID); err != nil {
+				t.Errorf("s.RemoveNIC(%d): %s", tunnelNICID, err)
+			}
+			tunnels[i].Wait()
+		}
+	})
+	return stacks, tunnels
+}
+
+// exchange sends a datagram from the overlay address of stacks[from] to the
+// overlay address of stacks[to], and checks that it is received.
+func exchange(t *testing.T, stacks [2]*stack.Stack, from, to int) {
+	t.Helper()
+
+	var wq waiter.Queue
+	we, ch := waiter.NewChannelEntry(nil)
+	wq.EventRegister(&we, waiter.EventIn)
+	defer wq.EventUnregister(&we)
+	rcv, err := stacks[to].NewEndpoint(udp.ProtocolNumber, ipv4.ProtocolNumber, &wq)
+	if err != nil {
+		t.Fatalf("NewEndpoint(%d, %d, _): %s", udp.ProtocolNumber, ipv4.ProtocolNumber, err)
+	}
+	defer rcv.Close()
+	if err := rcv.Bind(tcpip.FullAddress{Addr: overlayAddrs[to], Port: port}); err != nil {
+		t.Fatalf("rcv.Bind(_): %s", err)
+	}
+
+	snd, err := stacks[from].NewEndpoint(udp.ProtocolNumber, ipv4.ProtocolNumber, &waiter.Queue{})
+	if err != nil {
+		t.Fatalf("NewEndpoint(%d, %d, _): %s", udp.ProtocolNumber, ipv4.ProtocolNumber, err)
+	}
+	defer snd.Close()
+	data := []byte{1, 2, 3, 4}
+	dst := tcpip.FullAddress{Addr: overlayAddrs[to], Port: port}
+	for {
+		_, err := snd.Write(bytes.NewReader(data), tcpip.WriteOptions{To: &dst})
+		if err == nil {
+			break
+		}
+		// The first write may start link address resolution.
+		if err != tcpip.ErrWouldBlock {
+			t.Fatalf("snd.Write(_, _): %s", err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	select {
+	case <-ch:
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for the datagram")
+	}
+	var buf bytes.Buffer
+	res, err := rcv.Read(&buf, tcpip.ReadOptions{NeedRemoteAddr: true})
+	if err != nil {
+		t.Fatalf("rcv.Read(_, _): %s", err)
+	}
+	if !bytes.Equal(buf.Bytes(), data) {
+		t.Errorf("got data = %x, want = %x", buf.Bytes(), data)
+	}
+	if res.RemoteAddr.Addr != overlayAddrs[from] {
+		t.Errorf("got res.RemoteAddr.Addr = %s, want = %s", res.RemoteAddr.Addr, overlayAddrs[from])
+	}
+}
+
+func TestTunnel(t *testing.T) {
+	for _, kind := range []tunnel.Kind{tunnel.VXLAN, tunnel.GENEVE} {
+		t.Run(kind.String(), func(t *testing.T) {
+			stacks, _ := newHosts(t, func(i int) tunnel.Options {
+				return tunnel.Options{
+					Kind:   kind,
+					VNI:    vni,
+					Remote: underlayAddrs[1-i],
+				}
+			})
+			exchange(t, stacks, 0, 1)
+			exchange(t, stacks, 1, 0)
+		})
+	}
+}
+
+func TestTunnelVNIMismatch(t *testing.T) {
+	stacks, tunnels := newHosts(t, func(i int) tunnel.Options {
+		return tunnel.Options{
+			Kind:   tunnel.VXLAN,
+			VNI:    vni + uint32(i),
+			Remote: underlayAddrs[1-i],
+		}
+	})
+
+	var wq waiter.Queue
+	we, ch := waiter.NewChannelEntry(nil)
+	wq.EventRegister(&we, waiter.EventIn)
+	defer wq.EventUnregister(&we)
+	rcv, err := stacks[1].NewEndpoint(udp.ProtocolNumber, ipv4.ProtocolNumber, &wq)
+	if err != nil {
+		t.Fatalf("NewEndpoint(%d, %d, _): %s", udp.ProtocolNumber, ipv4.ProtocolNumber, err)
+	}
+	defer rcv.Close()
+	if err := rcv.Bind(tcpip.FullAddress{Port: port}); err != nil {
+		t.Fatalf("rcv.Bind(_): %s", err)
+	}
+
+	// Pretend the destination was resolved so that a frame is sent without
+	// waiting for link address resolution, which can't complete.
+	stacks[0].AddStaticNeighbor(tunnelNICID, overlayAddrs[1], tunnels[1].LinkAddress())
+	snd, err := stacks[0].NewEndpoint(udp.ProtocolNumber, ipv4.ProtocolNumber, &waiter.Queue{})
+	if err != nil {
+		t.Fatalf("NewEndpoint(%d, %d, _): %s", udp.ProtocolNumber, ipv4.ProtocolNumber, err)
+	}
+	defer snd.Close()
+	to := tcpip.FullAddress{Addr: overlayAddrs[1], Port: port}
+	if _, err := snd.Write(bytes.NewReader([]byte{1}), tcpip.WriteOptions{To: &to}); err != nil {
+		t.Fatalf("snd.Write(_, _): %s", err)
+	}
+
+	select {
+	case <-ch:
+		t.Fatal("received a datagram sent with another VNI")
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestTunnelLearning(t *testing.T) {
+	// Only the first host knows its remote tunnel endpoint, the second one
+	// learns it from the frames it receives.
+	stacks, tunnels := newHosts(t, func(i int) tunnel.Options {
+		opts := tunnel.Options{
+			Kind:     tunnel.VXLAN,
+			VNI:      vni,
+			Learning: true,
+		}
+		if i == 0 {
+			opts.Remote = underlayAddrs[1]
+		}
+		return opts
+	})
+	exchange(t, stacks, 0, 1)
+	exchange(t, stacks, 1, 0)
+
+	remotes := tunnels[1].Remotes()
+	if got, want := remotes[tunnels[0].LinkAddress()], underlayAddrs[0]; got != want {
+		t.Errorf("got remote of %s = %s, want = %s", tunnels[0].LinkAddress(), got, want)
+	}
+
+	if err := tunnels[1].RemoveRemote(tunnels[0].LinkAddress()); err != nil {
+		t.Fatalf("RemoveRemote(%s): %s", tunnels[0].LinkAddress(), err)
+	}
+	if err := tunnels[1].RemoveRemote(tunnels[0].LinkAddress()); err != tcpip.ErrBadAddress {
+		t.Errorf("got RemoveRemote(%s) = %s, want = %s", tunnels[0].LinkAddress(), err, tcpip.ErrBadAddress)
+	}
+}
+
+func TestHeaders(t *testing.T) {
+	v := header.VXLAN(make([]byte, header.VXLANMinimumSize))
+	v.Encode(vni)
+	if !v.IsValid() {
+		t.Error("got v.IsValid() = false, want = true")
+	}
+	if got := v.VNI(); got != vni {
+		t.Errorf("got v.VNI() = %d, want = %d", got, vni)
+	}
+
+	g := header.GENEVE(make([]byte, header.GENEVEMinimumSize))
+	g.Encode(&header.GENEVEFields{
+		Protocol: header.GENEVETransparentEthernetBridging,
+		VNI:      header.MaxVNI,
+	})
+	if got := g.Version(); got != header.GENEVEVersion {
+		t.Errorf("got g.Version() = %d, want = %d", got, header.GENEVEVersion)
+	}
+	if got := g.HeaderLength(); got != header.GENEVEMinimumSize {
+		t.Errorf("got g.HeaderLength() = %d, want = %d", got, header.GENEVEMinimumSize)
+	}
+	if got := g.Protocol(); got != header.GENEVETransparentEthernetBridging {
+		t.Errorf("got g.Protocol() = %d, want = %d", got, header.GENEVETransparentEthernetBridging)
+	}
+	if got := g.VNI(); got != header.MaxVNI {
+		t.Errorf("got g.VNI() = %d, want = %d", got, header.MaxVNI)
+	}
+	if g.OAM() || g.Critical() {
+		t.Errorf("got (g.OAM(), g.Critical()) = (%t, %t), want = (false, false)", g.OAM(), g.Critical())
+	}
+}
@@ -126,6 +126,35 @@ type multicastGroupState struct {
 	//
 	// Must not be nil.
 	delayedReportJob *tcpip.Job
+
+	// excludeJoins is the number of joins in exclude mode. The remaining joins
+	// are in include mode.
+	excludeJoins uint64
+
+	// includeSources and excludeSources hold the number of include and exclude
+	// mode joins that list a source address.
+	includeSources map[tcpip.Address]uint64
+	excludeSources map[tcpip.Address]uint64
+
+	// The fields below are only used when version 2 of the protocol is
+	// performed.
+
+	// reportedFilter is the source filter of the group as known to the
+	// multicast routers on the network.
+	reportedFilter tcpip.MulticastSourceFilter
+
+	// transmissionsLeft is the number of state change reports left to send for
+	// the last change of the group's source filter.
+	transmissionsLeft uint8
+
+	// stateChangeJob is used to retransmit state change reports.
+	//
+	// Must not be nil.
+	stateChangeJob *tcpip.Job
+
+	// queriedSources holds the sources of the pending group-and-source specific
+	// queries for the group. It is nil if a group specific query is pending.
+	queriedSources map[tcpip.Address]struct{}
 }
 
 // GenericMulticastProtocolOptions holds options for the generic multicast
@@ -154,6 +183,12 @@ type GenericMulticastProtocolOptions struct {
 	// it will be left in the non member/listener state, and packets will never
 	// be sent for it.
 	AllNodesAddress tcpip.Address
+
+	// ProtocolV2 is the implementation of version 2 of the multicast group
+	// protocol in use.
+	//
+	// If nil, only version 1 of the protocol will be performed.
+	ProtocolV2 MulticastGroupProtocolV2
 }
 
 // MulticastGroupProtocol is a multicast group protocol whose core state machine
@@ -210,6 +245,31 @@ type GenericMulticastProtocolState struct {
 
 	// protocolMU is the mutex used to protect the protocol.
 	protocolMU *sync.RWMutex
+
+	// v1Compatibility is true if version 1 of the protocol is being performed
+	// even though version 2 is supported, because a version 1 querier is
+	// present on the network.
+	v1Compatibility bool
+
+	// robustnessVariable is the number of state change reports sent for each
+	// change of a group's source filter. It is learned from the version 2
+	// queries received.
+	robustnessVariable uint8
+
+	// generalQueryV2Job is used to delay sending responses to version 2 general
+	// queries.
+	//
+	// Must not be nil.
+	generalQueryV2Job *tcpip.Job
+
+	// generalQueryV2Deadline is the monotonic time at which generalQueryV2Job
+	// fires, if generalQueryV2Pending is true.
+	generalQueryV2Deadline int64
+	generalQueryV2Pending  bool
+
+	// generalQueryV2Queued is true if a response to a version 2 general query
+	// failed to be sent and is waiting to be retransmitted.
+	generalQueryV2Queued bool
 }
 
 // Init initializes the Generic Multicast Protocol state.
@@ -228,10 +288,15 @@ func (g *GenericMulticastProtocolState) Init(protocolMU *sync.RWMutex, opts Gene
 	}
 
 	*g = GenericMulticastProtocolState{
-		opts:        opts,
-		memberships: make(map[tcpip.Address]multicastGroupState),
-		protocolMU:  protocolMU,
+		opts:               opts,
+		memberships:        make(map[tcpip.Address]multicastGroupState),
+		protocolMU:         protocolMU,
+		robustnessVariable: defaultRobustnessVariable,
 	}
+	g.generalQueryV2Job = tcpip.NewJob(opts.Clock, protocolMU, func() {
+		g.generalQueryV2Pending = false
+		g.maybeSendGeneralQueryResponseV2Locked()
+	})
 }
 
 // MakeAllNonMemberLocked transitions all groups to the non-member state.
@@ -246,9 +311,13 @@ func (g *GenericMulticastProtocolState) MakeAllNonMemberLocked() {
 		return
 	}
 
+	g.generalQueryV2Job.Cancel()
+	g.generalQueryV2Pending = false
+	g.generalQueryV2Queued = false
+
 	for groupAddress, info := range g.memberships {
 		g.transitionToNonMemberLocked(groupAddress, &info)
-		g.memberships[groupAddress] = info
+		g.storeGroupLocked(groupAddress, &info)
 	}
 }
 
@@ -275,6 +344,10 @@ func (g *GenericMulticastProtocolState) InitializeGroupsLocked() {
 //
 // Precondition: g.protocolMU must be locked.
 func (g *GenericMulticastProtocolState) SendQueuedReportsLocked() {
+	if g.generalQueryV2Queued {
+		g.maybeSendGeneralQueryResponseV2Locked()
+	}
+
 	for groupAddress, info := range g.memberships {
 		switch info.state {
 		case nonMember, delayingMember, idleMember:
@@ -295,77 +368,121 @@ func (g *GenericMulticastProtocolState) SendQueuedReportsLocked() {
 
 // JoinGroupLocked handles joining a new group.
 //
+// It is equivalent to joining the group in exclude mode without any source,
+// that is, receiving packets from all sources.
+//
 // Precondition: g.protocolMU must be locked.
 func (g *GenericMulticastProtocolState) JoinGroupLocked(groupAddress tcpip.Address) {
-	if info, ok := g.memberships[groupAddress]; ok {
-		// The group has already been joined.
-		info.joins++
-		g.memberships[groupAddress] = info
-		return
-	}
-
-	info := multicastGroupState{
-		// Since we just joined the group, its count is 1.
-		joins: 1,
-		// The state will be updated below, if required.
-		state:            nonMember,
-		lastToSendReport: false,
-		delayedReportJob: tcpip.NewJob(g.opts.Clock, g.protocolMU, func() {
-			if !g.opts.Protocol.Enabled() {
-				panic(fmt.Sprintf("delayed report job fired for group %s while the multicast group protocol is disabled", groupAddress))
-			}
-
-			info, ok := g.memberships[groupAddress]
-			if !ok {
-				panic(fmt.Sprintf("expected to find group state for group = %s", groupAddress))
-			}
-
-			g.maybeSendDelayedReportLocked(groupAddress, &info)
-			g.memberships[groupAddress] = info
-		}),
-	}
-
-	if g.opts.Protocol.Enabled() {
-		g.initializeNewMemberLocked(groupAddress, &info)
-	}
-
-	g.memberships[groupAddress] = info
+	g.ChangeGroupSourceFilterLocked(groupAddress, nil, &tcpip.MulticastSourceFilter{Mode: tcpip.MulticastFilterExclude})
 }
 
 // IsLocallyJoinedRLocked returns true if the group is locally joined.
 //
 // Precondition: g.protocolMU must be read locked.
 func (g *GenericMulticastProtocolState) IsLocallyJoinedRLocked(groupAddress tcpip.Address) bool {
-	_, ok := g.memberships[groupAddress]
-	return ok
+	info, ok := g.memberships[groupAddress]
+	return ok && info.joins != 0
 }
 
 // LeaveGroupLocked handles leaving the group.
 //
+// It undoes a previous call to JoinGroupLocked.
+//
 // Returns false if the group is not currently joined.
 //
 // Precondition: g.protocolMU must be locked.
 func (g *GenericMulticastProtocolState) LeaveGroupLocked(groupAddress tcpip.Address) bool {
+	return g.ChangeGroupSourceFilterLocked(groupAddress, &tcpip.MulticastSourceFilter{Mode: tcpip.MulticastFilterExclude}, nil)
+}
+
+// ChangeGroupSourceFilterLocked replaces the source filter of one of the joins
+// of the group. A nil old filter joins the group and a nil new filter leaves
+// it.
+//
+// The source filter of the group on the interface is the merge of the source
+// filters of its joins, as per RFC 3376 section 3.2 (for IGMPv3) and RFC 3810
+// section 4.2 (for MLDv2).
+//
+// Returns false if old is not nil and the group is not currently joined.
+//
+// Precondition: g.protocolMU must be locked.
+func (g *GenericMulticastProtocolState) ChangeGroupSourceFilterLocked(groupAddress tcpip.Address, old, new *tcpip.MulticastSourceFilter) bool {
 	info, ok := g.memberships[groupAddress]
-	if !ok {
+	if old != nil && (!ok || info.joins == 0) {
 		return false
 	}
+	if !ok {
+		info = g.newGroupStateLocked(groupAddress)
+	}
 
-	if info.joins == 0 {
-		panic(fmt.Sprintf("tried to leave group %s with a join count of 0", groupAddress))
+	wasJoined := info.joins != 0
+	if old != nil {
+		info.removeFilter(old)
 	}
-	info.joins--
-	if info.joins != 0 {
-		// If we still have outstanding joins, then do nothing further.
-		g.memberships[groupAddress] = info
-		return true
+	if new != nil {
+		info.addFilter(new)
 	}
 
-	g.transitionToNonMemberLocked(groupAddress, &info)
-	delete(g.memberships, groupAddress)
+	switch enabled := g.opts.Protocol.Enabled(); {
+	case !wasJoined && info.joins != 0 && info.state == nonMember:
+		if enabled {
+			g.initializeNewMemberLocked(groupAddress, &info)
+		}
+	case g.v2ModeLocked():
+		if enabled {
+			g.filterChangedLocked(groupAddress, &info)
+		}
+	case info.joins == 0:
+		g.transitionToNonMemberLocked(groupAddress, &info)
+	}
+
+	g.storeGroupLocked(groupAddress, &info)
 	return true
 }
 
+// SetV1CompatibilityLocked sets whether version 1 of the protocol must be
+// performed even though version 2 is supported.
+//
+// As per RFC 3376 section 7.2.1 (for IGMPv3) and RFC 3810 section 8.2.1 (for
+// MLDv2), the protocol is expected to enter compatibility mode when it receives
+// a version 1 query and to leave it when the older version querier present
+// timer expires.
+//
+// Precondition: g.protocolMU must be locked.
+func (g *GenericMulticastProtocolState) SetV1CompatibilityLocked(v bool) {
+	if g.opts.ProtocolV2 == nil || g.v1Compatibility == v {
+		return
+	}
+	g.v1Compatibility = v
+
+	// As per RFC 3376 section 7.2.1 (for IGMPv3),
+	//
+	//   Whenever a host changes its compatibility mode, it cancels all its
+	//   pending response and retransmission timers.
+	//
+	// As per RFC 3810 section 8.2.1 (for MLDv2),
+	//
+	//   Whenever a host changes its compatibility mode, it cancels all its
+	//   pending responses and retransmission timers.
+	g.generalQueryV2Job.Cancel()
+	g.generalQueryV2Pending = false
+	g.generalQueryV2Queued = false
+
+	for groupAddress, info := range g.memberships {
+		info.delayedReportJob.Cancel()
+		info.stateChangeJob.Cancel()
+		info.transmissionsLeft = 0
+		info.queriedSources = nil
+		info.reportedFilter = info.filter()
+		if info.state != nonMember {
+			info.state = idleMember
+			// The group was reported by the last report we sent for it.
+			info.lastToSendReport = true
+		}
+		g.storeGroupLocked(groupAddress, &info)
+	}
+}
+
 // HandleQueryLocked handles a query message with the specified maximum response
 // time.
 //
@@ -395,10 +512,13 @@ func (g *GenericMulticastProtocolState) HandleQueryLocked(groupAddress tcpip.Add
 	if groupAddress.Unspecified() {
 		// This is a general query as the group address is unspecified.
 		for groupAddress, info := range g.memberships {
+			if info.joins == 0 {
+				continue
+			}
 			g.setDelayTimerForAddressRLocked(groupAddress, &info, maxResponseTime)
 			g.memberships[groupAddress] = info
 		}
-	} else if info, ok := g.memberships[groupAddress]; ok {
+	} else if info, ok := g.memberships[groupAddress]; ok && info.joins != 0 {
 		g.setDelayTimerForAddressRLocked(groupAddress, &info, maxResponseTime)
 		g.memberships[groupAddress] = info
 	}
@@ -411,7 +531,10 @@ func (g *GenericMulticastProtocolState) HandleQueryLocked(groupAddress tcpip.Add
 //
 // Precondition: g.protocolMU must be locked.
 func (g *GenericMulticastProtocolState) HandleReportLocked(groupAddress tcpip.Address) {
-	if !g.opts.Protocol.Enabled() {
+	// As per RFC 3376 section 5.2 (for IGMPv3) and RFC 3810 section 6.2 (for
+	// MLDv2), reports of other hosts are not suppressed by version 2 of the
+	// protocol.
+	if !g.opts.Protocol.Enabled() || g.v2ModeLocked() {
 		return
 	}
 
@@ -463,6 +586,12 @@ func (g *GenericMulticastProtocolState) initializeNewMemberLocked(groupAddress t
 		return
 	}
 
+	if g.v2ModeLocked() {
+		info.state = idleMember
+		g.filterChangedLocked(groupAddress, info)
+		return
+	}
+
 	info.state = pendingMember
 	g.maybeSendInitialReportLocked(groupAddress, info)
 }
@@ -512,6 +641,11 @@ func (g *GenericMulticastProtocolState) maybeSendDelayedReportLocked(groupAddres
 		panic(fmt.Sprintf("host must be in delaying or queued delaying member state to send delayed reports; group = %s, state = %d", groupAddress, info.state))
 	}
 
+	if g.v2ModeLocked() {
+		g.maybeSendGroupQueryResponseV2Locked(groupAddress, info)
+		return
+	}
+
 	sent, err := g.opts.Protocol.SendReport(groupAddress)
 	if err == nil && sent {
 		info.lastToSendReport = true
@@ -604,7 +738,11 @@ func (g *GenericMulticastProtocolState) transitionToNonMemberLocked(groupAddress
 	}
 
 	info.delayedReportJob.Cancel()
-	g.maybeSendLeave(groupAddress, info.lastToSendReport)
+	if g.v2ModeLocked() {
+		g.sendFinalStateChangeReportLocked(groupAddress, info)
+	} else {
+		g.maybeSendLeave(groupAddress, info.lastToSendReport)
+	}
 	info.lastToSendReport = false
 	info.state = nonMember
 }
@@ -804,3 +804,218 @@ func TestQueuedPackets(t *testing.T) {
 		t.Fatalf("mockMulticastGroupProtocol mismatch (-want +got):\n%s", diff)
 	}
 }
+
+var _ ip.MulticastGroupProtocol = (*mockMulticastGroupProtocolV2)(nil)
+var _ ip.MulticastGroupProtocolV2 = (*mockMulticastGroupProtocolV2)(nil)
+
+type mockMulticastGroupProtocolV2ProtectedFields struct {
+	sync.RWMutex
+
+	genericMulticastGroup ip.GenericMulticastProtocolState
+	reports               [][]ip.MulticastGroupRecord
+	v1Reports             []tcpip.Address
+}
+
+type mockMulticastGroupProtocolV2 struct {
+	t *testing.T
+
+	mu mockMulticastGroupProtocolV2ProtectedFields
+}
+
+func (m *mockMulticastGroupProtocolV2) init(opts ip.GenericMulticastProtocolOptions) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	opts.Protocol = m
+	opts.ProtocolV2 = m
+	m.mu.genericMulticastGroup.Init(&m.mu.RWMutex, opts)
+}
+
+func (m *mockMulticastGroupProtocolV2) changeSourceFilter(addr tcpip.Address, old, new *tcpip.MulticastSourceFilter) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.mu.genericMulticastGroup.ChangeGroupSourceFilterLocked(addr, old, new)
+}
+
+func (m *mockMulticastGroupProtocolV2) handleQueryV2(addr tcpip.Address, maxRespTime time.Duration, sources []tcpip.Address) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.mu.genericMulticastGroup.HandleQueryV2Locked(addr, maxRespTime, sources, 0 /* robustnessVariable */)
+}
+
+func (m *mockMulticastGroupProtocolV2) handleQuery(addr tcpip.Address, maxRespTime time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.mu.genericMulticastGroup.HandleQueryLocked(addr, maxRespTime)
+}
+
+func (m *mockMulticastGroupProtocolV2) setV1Compatibility(v bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.mu.genericMulticastGroup.SetV1CompatibilityLocked(v)
+}
+
+func (m *mockMulticastGroupProtocolV2) isLocallyJoined(addr tcpip.Address) bool {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.mu.genericMulticastGroup.IsLocallyJoinedRLocked(addr)
+}
+
+// Enabled implements ip.MulticastGroupProtocol.
+func (*mockMulticastGroupProtocolV2) Enabled() bool {
+	return true
+}
+
+// SendReport implements ip.MulticastGroupProtocol.
+//
+// Precondition: m.mu must be locked.
+func (m *mockMulticastGroupProtocolV2) SendReport(groupAddress tcpip.Address) (bool, *tcpip.Error) {
+	m.mu.v1Reports = append(m.mu.v1Reports, groupAddress)
+	return true, nil
+}
+
+// SendLeave implements ip.MulticastGroupProtocol.
+func (*mockMulticastGroupProtocolV2) SendLeave(tcpip.Address) *tcpip.Error {
+	return nil
+}
+
+// SendReportV2 implements ip.MulticastGroupProtocolV2.
+//
+// Precondition: m.mu must be locked.
+func (m *mockMulticastGroupProtocolV2) SendReportV2(records []ip.MulticastGroupRecord) (bool, *tcpip.Error) {
+	if m.mu.TryLock() {
+		m.mu.Unlock()
+		m.t.Fatal("got write lock, expected to not take the lock; generic multicast protocol must take the write lock before sending reports")
+	}
+
+	m.mu.reports = append(m.mu.reports, records)
+	return true, nil
+}
+
+func (m *mockMulticastGroupProtocolV2) check(reports [][]ip.MulticastGroupRecord, v1Reports []tcpip.Address) string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	diff := cmp.Diff(reports, m.mu.reports)
+	if diff == "" {
+		diff = cmp.Diff(v1Reports, m.mu.v1Reports)
+	}
+	m.mu.reports = nil
+	m.mu.v1Reports = nil
+	return diff
+}
+
+func TestJoinLeaveGroupV2(t *testing.T) {
+	mgp := mockMulticastGroupProtocolV2{t: t}
+	clock := faketime.NewManualClock()
+
+	mgp.init(ip.GenericMulticastProtocolOptions{
+		Rand:                      rand.New(rand.NewSource(0)),
+		Clock:                     clock,
+		MaxUnsolicitedReportDelay: maxUnsolicitedReportDelay,
+		AllNodesAddress:           addr2,
+	})
+
+	// Joining a group should send a state change report immediately and
+	// retransmit it once.
+	exclude := tcpip.MulticastSourceFilter{Mode: tcpip.MulticastFilterExclude}
+	mgp.changeSourceFilter(addr1, nil, &exclude)
+	toEx := []ip.MulticastGroupRecord{{Type: ip.MulticastGroupRecordChangeToExcludeMode, GroupAddress: addr1}}
+	if diff := mgp.check([][]ip.MulticastGroupRecord{toEx}, nil); diff != "" {
+		t.Errorf("mockMulticastGroupProtocolV2 mismatch (-want +got):\n%s", diff)
+	}
+	clock.Advance(time.Second)
+	if diff := mgp.check([][]ip.MulticastGroupRecord{toEx}, nil); diff != "" {
+		t.Errorf("mockMulticastGroupProtocolV2 mismatch (-want +got):\n%s", diff)
+	}
+	clock.Advance(time.Hour)
+	if diff := mgp.check(nil, nil); diff != "" {
+		t.Errorf("mockMulticastGroupProtocolV2 mismatch (-want +got):\n%s", diff)
+	}
+
+	// The all-nodes group is never reported.
+	mgp.changeSourceFilter(addr2, nil, &exclude)
+	clock.Advance(time.Hour)
+	if diff := mgp.check(nil, nil); diff != "" {
+		t.Errorf("mockMulticastGroupProtocolV2 mismatch (-want +got):\n%s", diff)
+	}
+
+	// Leaving the group should change it to include mode.
+	if !mgp.changeSourceFilter(addr1, &exclude, nil) {
+		t.Fatalf("got changeSourceFilter(%s, _, nil) = false, want = true", addr1)
+	}
+	if mgp.isLocallyJoined(addr1) {
+		t.Errorf("got isLocallyJoined(%s) = true, want = false", addr1)
+	}
+	toIn := []ip.MulticastGroupRecord{{Type: ip.MulticastGroupRecordChangeToIncludeMode, GroupAddress: addr1}}
+	if diff := mgp.check([][]ip.MulticastGroupRecord{toIn}, nil); diff != "" {
+		t.Errorf("mockMulticastGroupProtocolV2 mismatch (-want +got):\n%s", diff)
+	}
+	clock.Advance(time.Second)
+	if diff := mgp.check([][]ip.MulticastGroupRecord{toIn}, nil); diff != "" {
+		t.Errorf("mockMulticastGroupProtocolV2 mismatch (-want +got):\n%s", diff)
+	}
+	if mgp.changeSourceFilter(addr1, &exclude, nil) {
+		t.Errorf("got changeSourceFilter(%s, _, nil) = true, want = false", addr1)
+	}
+}
+
+func TestSourceFilterV2(t *testing.T) {
+	mgp := mockMulticastGroupProtocolV2{t: t}
+	clock := faketime.NewManualClock()
+
+	mgp.init(ip.GenericMulticastProtocolOptions{
+		Rand:                      rand.New(rand.NewSource(0)),
+		Clock:                     clock,
+		MaxUnsolicitedReportDelay: maxUnsolicitedReportDelay,
+		AllNodesAddress:           addr2,
+	})
+
+	include3 := tcpip.MulticastSourceFilter{Mode: tcpip.MulticastFilterInclude, Sources: []tcpip.Address{addr3}}
+	include4 := tcpip.MulticastSourceFilter{Mode: tcpip.MulticastFilterInclude, Sources: []tcpip.Address{addr4}}
+	mgp.changeSourceFilter(addr1, nil, &include3)
+	mgp.changeSourceFilter(addr1, nil, &include4)
+	clock.Advance(time.Hour)
+	if diff := mgp.check([][]ip.MulticastGroupRecord{
+		{{Type: ip.MulticastGroupRecordAllowNewSources, GroupAddress: addr1, Sources: []tcpip.Address{addr3}}},
+		{{Type: ip.MulticastGroupRecordChangeToIncludeMode, GroupAddress: addr1, Sources: []tcpip.Address{addr3, addr4}}},
+		{{Type: ip.MulticastGroupRecordChangeToIncludeMode, GroupAddress: addr1, Sources: []tcpip.Address{addr3, addr4}}},
+	}, nil); diff != "" {
+		t.Errorf("mockMulticastGroupProtocolV2 mismatch (-want +got):\n%s", diff)
+	}
+
+	// A general query is answered with the current state of the group.
+	mgp.handleQueryV2("", time.Second, nil)
+	clock.Advance(time.Second)
+	if diff := mgp.check([][]ip.MulticastGroupRecord{
+		{{Type: ip.MulticastGroupRecordModeIsInclude, GroupAddress: addr1, Sources: []tcpip.Address{addr3, addr4}}},
+	}, nil); diff != "" {
+		t.Errorf("mockMulticastGroupProtocolV2 mismatch (-want +got):\n%s", diff)
+	}
+
+	// A group-and-source specific query is answered with the queried sources
+	// that are accepted.
+	mgp.handleQueryV2(addr1, time.Second, []tcpip.Address{addr2, addr4})
+	clock.Advance(time.Second)
+	if diff := mgp.check([][]ip.MulticastGroupRecord{
+		{{Type: ip.MulticastGroupRecordModeIsInclude, GroupAddress: addr1, Sources: []tcpip.Address{addr4}}},
+	}, nil); diff != "" {
+		t.Errorf("mockMulticastGroupProtocolV2 mismatch (-want +got):\n%s", diff)
+	}
+
+	// Dropping a source blocks it.
+	mgp.changeSourceFilter(addr1, &include3, nil)
+	clock.Advance(time.Hour)
+	block := []ip.MulticastGroupRecord{{Type: ip.MulticastGroupRecordBlockOldSources, GroupAddress: addr1, Sources: []tcpip.Address{addr3}}}
+	if diff := mgp.check([][]ip.MulticastGroupRecord{block, block}, nil); diff != "" {
+		t.Errorf("mockMulticastGroupProtocolV2 mismatch (-want +got):\n%s", diff)
+	}
+
+	// Version 1 queries are answered with version 1 reports in compatibility
+	// mode.
+	mgp.setV1Compatibility(true)
+	mgp.handleQuery(addr1, time.Second)
+	clock.Advance(time.Second)
+	if diff := mgp.check(nil, []tcpip.Address{addr1}); diff != "" {
+		t.Errorf("mockMulticastGroupProtocolV2 mismatch (-want +got):\n%s", diff)
+	}
+}
@@ -0,0 +1,515 @@
+// Copyright 2021 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ip
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"gvisor.dev/gvisor/pkg/tcpip"
+)
+
+const (
+	// defaultRobustnessVariable is the default number of state change reports
+	// sent for each change of a group's source filter.
+	//
+	// As per RFC 3376 section 8.1 (for IGMPv3) and RFC 3810 section 9.1 (for
+	// MLDv2), the default value of the Robustness Variable is 2.
+	defaultRobustnessVariable = 2
+
+	// unsolicitedReportIntervalV2 is the maximum amount of time to wait between
+	// retransmissions of state change reports.
+	//
+	// As per RFC 3376 section 8.11 (for IGMPv3) and RFC 3810 section 9.11 (for
+	// MLDv2), the default Unsolicited Report Interval is 1 second.
+	unsolicitedReportIntervalV2 = time.Second
+)
+
+// MulticastGroupRecordType is the type of a multicast group record sent in a
+// version 2 report.
+//
+// The values match the record types of IGMPv3 (RFC 3376 section 4.2.12) and
+// MLDv2 (RFC 3810 section 5.2.12).
+type MulticastGroupRecordType uint8
+
+const (
+	// MulticastGroupRecordModeIsInclude is a current state record for a group
+	// in include mode.
+	MulticastGroupRecordModeIsInclude MulticastGroupRecordType = 1
+
+	// MulticastGroupRecordModeIsExclude is a current state record for a group
+	// in exclude mode.
+	MulticastGroupRecordModeIsExclude MulticastGroupRecordType = 2
+
+	// MulticastGroupRecordChangeToIncludeMode is a state change record for a
+	// group that changed to include mode.
+	MulticastGroupRecordChangeToIncludeMode MulticastGroupRecordType = 3
+
+	// MulticastGroupRecordChangeToExcludeMode is a state change record for a
+	// group that changed to exclude mode.
+	MulticastGroupRecordChangeToExcludeMode MulticastGroupRecordType = 4
+
+	// MulticastGroupRecordAllowNewSources is a state change record listing
+	// sources that packets are now accepted from.
+	MulticastGroupRecordAllowNewSources MulticastGroupRecordType = 5
+
+	// MulticastGroupRecordBlockOldSources is a state change record listing
+	// sources that packets are no longer accepted from.
+	MulticastGroupRecordBlockOldSources MulticastGroupRecordType = 6
+)
+
+// MulticastGroupRecord is a multicast group record sent in a version 2 report.
+type MulticastGroupRecord struct {
+	Type         MulticastGroupRecordType
+	GroupAddress tcpip.Address
+	Sources      []tcpip.Address
+}
+
+// MulticastGroupProtocolV2 is the version of a multicast group protocol that
+// supports source filtering, that is, IGMPv3 as defined by RFC 3376 or MLDv2 as
+// defined by RFC 3810.
+type MulticastGroupProtocolV2 interface {
+	// SendReportV2 sends a version 2 report holding the specified records.
+	//
+	// Returns false if the caller should queue the report to be sent later. Note,
+	// returning false does not mean that the receiver hit an error.
+	SendReportV2(records []MulticastGroupRecord) (sent bool, err *tcpip.Error)
+}
+
+// addFilter accounts for a new join of the group with the given source filter.
+func (s *multicastGroupState) addFilter(f *tcpip.MulticastSourceFilter) {
+	s.joins++
+	if f.Mode == tcpip.MulticastFilterExclude {
+		s.excludeJoins++
+		s.excludeSources = addSources(s.excludeSources, f.Sources)
+	} else {
+		s.includeSources = addSources(s.includeSources, f.Sources)
+	}
+}
+
+// removeFilter accounts for a join of the group with the given source filter
+// being left.
+func (s *multicastGroupState) removeFilter(f *tcpip.MulticastSourceFilter) {
+	s.joins--
+	if f.Mode == tcpip.MulticastFilterExclude {
+		s.excludeJoins--
+		removeSources(s.excludeSources, f.Sources)
+	} else {
+		removeSources(s.includeSources, f.Sources)
+	}
+}
+
+// filter returns the source filter of the group on the interface.
+//
+// As per RFC 3376 section 3.2 (for IGMPv3),
+//
+//   If any of the socket records for the given interface and multicast
+//   address has a filter mode of EXCLUDE, the interface record's filter mode
+//   is EXCLUDE, and the interface record's source list is the intersection of
+//   the source lists of all socket records in EXCLUDE mode, minus those
+//   source addresses that appear in any socket record in INCLUDE mode.
+//   Otherwise, the interface record's filter mode is INCLUDE, and the
+//   interface record's source list is the union of the source lists of all
+//   the socket records for the given interface and multicast address.
+//
+// RFC 3810 section 4.2 defines the same rules for MLDv2.
+func (s *multicastGroupState) filter() tcpip.MulticastSourceFilter {
+	var f tcpip.MulticastSourceFilter
+	if s.excludeJoins != 0 {
+		f.Mode = tcpip.MulticastFilterExclude
+		for src, n := range s.excludeSources {
+			if n == s.excludeJoins && s.includeSources[src] == 0 {
+				f.Sources = append(f.Sources, src)
+			}
+		}
+	} else {
+		f.Mode = tcpip.MulticastFilterInclude
+		for src := range s.includeSources {
+			f.Sources = append(f.Sources, src)
+		}
+	}
+	sortAddresses(f.Sources)
+	return f
+}
+
+func addSources(m map[tcpip.Address]uint64, sources []tcpip.Address) map[tcpip.Address]uint64 {
+	if m == nil && len(sources) != 0 {
+		m = make(map[tcpip.Address]uint64)
+	}
+	for _, src := range sources {
+		m[src]++
+	}
+	return m
+}
+
+func removeSources(m map[tcpip.Address]uint64, sources []tcpip.Address) {
+	for _, src := range sources {
+		if m[src] <= 1 {
+			delete(m, src)
+		} else {
+			m[src]--
+		}
+	}
+}
+
+func sortAddresses(a []tcpip.Address) {
+	sort.Slice(a, func(i, j int) bool { return a[i] < a[j] })
+}
+
+// addressesDifference returns the addresses of a that are not in b.
+func addressesDifference(a, b []tcpip.Address) []tcpip.Address {
+	var d []tcpip.Address
+	for _, x := range a {
+		found := false
+		for _, y := range b {
+			if x == y {
+				found = true
+				break
+			}
+		}
+		if !found {
+			d = append(d, x)
+		}
+	}
+	return d
+}
+
+// stateChangeRecords returns the records of a state change report for a group
+// whose source filter changed from old to new, as per RFC 3376 section 5.1
+// (for IGMPv3) and RFC 3810 section 6.1 (for MLDv2).
+func stateChangeRecords(groupAddress tcpip.Address, old, new tcpip.MulticastSourceFilter) []MulticastGroupRecord {
+	if old.Mode != new.Mode {
+		t := MulticastGroupRecordChangeToIncludeMode
+		if new.Mode == tcpip.MulticastFilterExclude {
+			t = MulticastGroupRecordChangeToExcludeMode
+		}
+		return []MulticastGroupRecord{{Type: t, GroupAddress: groupAddress, Sources: new.Sources}}
+	}
+
+	allow := addressesDifference(new.Sources, old.Sources)
+	block := addressesDifference(old.Sources, new.Sources)
+	if new.Mode == tcpip.MulticastFilterExclude {
+		allow, block = block, allow
+	}
+
+	var records []MulticastGroupRecord
+	if len(allow) != 0 {
+		records = append(records, MulticastGroupRecord{Type: MulticastGroupRecordAllowNewSources, GroupAddress: groupAddress, Sources: allow})
+	}
+	if len(block) != 0 {
+		records = append(records, MulticastGroupRecord{Type: MulticastGroupRecordBlockOldSources, GroupAddress: groupAddress, Sources: block})
+	}
+	return records
+}
+
+// currentStateRecord returns the current state record for a group with the
+// given source filter.
+func currentStateRecord(groupAddress tcpip.Address, f tcpip.MulticastSourceFilter) MulticastGroupRecord {
+	t := MulticastGroupRecordModeIsInclude
+	if f.Mode == tcpip.MulticastFilterExclude {
+		t = MulticastGroupRecordModeIsExclude
+	}
+	return MulticastGroupRecord{Type: t, GroupAddress: groupAddress, Sources: f.Sources}
+}
+
+// newGroupStateLocked returns the state of a group that is not joined yet.
+//
+// Precondition: g.protocolMU must be locked.
+func (g *GenericMulticastProtocolState) newGroupStateLocked(groupAddress tcpip.Address) multicastGroupState {
+	return multicastGroupState{
+		// The state will be updated by the caller, if required.
+		state:            nonMember,
+		lastToSendReport: false,
+		delayedReportJob: tcpip.NewJob(g.opts.Clock, g.protocolMU, func() {
+			if !g.opts.Protocol.Enabled() {
+				panic(fmt.Sprintf("delayed report job fired for group %s while the multicast group protocol is disabled", groupAddress))
+			}
+
+			info, ok := g.memberships[groupAddress]
+			if !ok {
+				panic(fmt.Sprintf("expected to find group state for group = %s", groupAddress))
+			}
+
+			g.maybeSendDelayedReportLocked(groupAddress, &info)
+			g.memberships[groupAddress] = info
+		}),
+		stateChangeJob: tcpip.NewJob(g.opts.Clock, g.protocolMU, func() {
+			info, ok := g.memberships[groupAddress]
+			if !ok {
+				panic(fmt.Sprintf("expected to find group state for group = %s", groupAddress))
+			}
+
+			g.sendStateChangeReportLocked(groupAddress, &info)
+			g.storeGroupLocked(groupAddress, &info)
+		}),
+	}
+}
+
+// storeGroupLocked stores the state of a group.
+//
+// The group is forgotten once it is no longer joined and its last state change
+// reports are sent.
+//
+// Precondition: g.protocolMU must be locked.
+func (g *GenericMulticastProtocolState) storeGroupLocked(groupAddress tcpip.Address, info *multicastGroupState) {
+	if info.joins == 0 && info.transmissionsLeft == 0 {
+		info.delayedReportJob.Cancel()
+		info.stateChangeJob.Cancel()
+		delete(g.memberships, groupAddress)
+		return
+	}
+	g.memberships[groupAddress] = *info
+}
+
+// v2ModeLocked returns true if version 2 of the protocol is being performed.
+//
+// Precondition: g.protocolMU must be read locked.
+func (g *GenericMulticastProtocolState) v2ModeLocked() bool {
+	return g.opts.ProtocolV2 != nil && !g.v1Compatibility
+}
+
+// filterChangedLocked starts the transmission of state change reports after
+// the source filter of a group changed.
+//
+// Precondition: g.protocolMU must be locked.
+func (g *GenericMulticastProtocolState) filterChangedLocked(groupAddress tcpip.Address, info *multicastGroupState) {
+	if info.state == nonMember || groupAddress == g.opts.AllNodesAddress {
+		return
+	}
+
+	if info.transmissionsLeft != 0 {
+		// The state change reports of a previous change are still being
+		// retransmitted. Report the filter mode of the group again so that the
+		// multicast routers learn about its complete state, whichever reports
+		// they missed.
+		info.reportedFilter = tcpip.MulticastSourceFilter{Mode: tcpip.MulticastFilterExclude}
+		if info.filter().Mode == tcpip.MulticastFilterExclude {
+			info.reportedFilter.Mode = tcpip.MulticastFilterInclude
+		}
+	}
+
+	// As per RFC 3376 section 5.1 (for IGMPv3),
+	//
+	//   To cover the possibility of the State-Change Report being missed by
+	//   one or more multicast routers, it is retransmitted [Robustness
+	//   Variable] - 1 more times, at intervals chosen at random from the range
+	//   (0, [Unsolicited Report Interval]).
+	//
+	// RFC 3810 section 6.1 defines the same behaviour for MLDv2.
+	info.transmissionsLeft = g.robustnessVariable
+	info.stateChangeJob.Cancel()
+	g.sendStateChangeReportLocked(groupAddress, info)
+}
+
+// sendStateChangeReportLocked sends the next state change report for a group
+// and schedules its retransmission, if required.
+//
+// Precondition: g.protocolMU must be locked.
+func (g *GenericMulticastProtocolState) sendStateChangeReportLocked(groupAddress tcpip.Address, info *multicastGroupState) {
+	current := info.filter()
+	records := stateChangeRecords(groupAddress, info.reportedFilter, current)
+	if len(records) == 0 {
+		info.transmissionsLeft = 0
+		return
+	}
+
+	if sent, err := g.opts.ProtocolV2.SendReportV2(records); err == nil && sent {
+		info.lastToSendReport = true
+		info.transmissionsLeft--
+	}
+	if info.transmissionsLeft == 0 {
+		info.reportedFilter = current
+		return
+	}
+	info.stateChangeJob.Schedule(g.calculateDelayTimerDuration(unsolicitedReportIntervalV2))
+}
+
+// sendFinalStateChangeReportLocked sends a single state change report for a
+// group that is no longer a member on the interface.
+//
+// Precondition: g.protocolMU must be locked.
+func (g *GenericMulticastProtocolState) sendFinalStateChangeReportLocked(groupAddress tcpip.Address, info *multicastGroupState) {
+	info.stateChangeJob.Cancel()
+	info.transmissionsLeft = 0
+	info.queriedSources = nil
+	if groupAddress != g.opts.AllNodesAddress {
+		if records := stateChangeRecords(groupAddress, info.reportedFilter, tcpip.MulticastSourceFilter{}); len(records) != 0 {
+			// Okay to ignore the error here, see maybeSendLeave.
+			_, _ = g.opts.ProtocolV2.SendReportV2(records)
+		}
+	}
+	info.reportedFilter = tcpip.MulticastSourceFilter{}
+}
+
+// HandleQueryV2Locked handles a version 2 query message with the specified
+// maximum response delay, sources and querier's robustness variable.
+//
+// If version 2 of the protocol is not being performed, the query is handled
+// as a version 1 query.
+//
+// Precondition: g.protocolMU must be locked.
+func (g *GenericMulticastProtocolState) HandleQueryV2Locked(groupAddress tcpip.Address, maxResponseDelay time.Duration, sources []tcpip.Address, robustnessVariable uint8) {
+	if !g.opts.Protocol.Enabled() {
+		return
+	}
+
+	if !g.v2ModeLocked() {
+		g.HandleQueryLocked(groupAddress, maxResponseDelay)
+		return
+	}
+
+	if robustnessVariable != 0 {
+		g.robustnessVariable = robustnessVariable
+	}
+
+	// As per RFC 3376 section 5.2 (for IGMPv3),
+	//
+	//   1. If there is a pending response to a previous General Query
+	//      scheduled sooner than the selected delay, no additional response
+	//      needs to be scheduled.
+	//
+	//   2. If the received Query is a General Query, the interface timer is
+	//      used to schedule a response to the General Query after the selected
+	//      delay. Any previously pending response to a General Query is
+	//      canceled.
+	//
+	//   3. If the received Query is a Group-Specific Query or a Group-and-
+	//      Source-Specific Query and there is no pending response to a
+	//      previous Query for this group, then the group timer is used to
+	//      schedule a report. If the received Query is a Group-and-Source-
+	//      Specific Query, the list of queried sources is recorded to be used
+	//      when generating a response.
+	//
+	//   4. If there already is a pending response to a previous Query
+	//      scheduled for this group, and either the new Query is a Group-
+	//      Specific Query or the recorded source-list associated with the
+	//      group is empty, then the group source-list is cleared and a single
+	//      response is scheduled using the group timer.
+	//
+	//   5. If the received Query is a Group-and-Source-Specific Query and
+	//      there is a pending response for this group with a non-empty
+	//      source-list, then the group source list is augmented to contain the
+	//      list of sources in the new Query and a single response is scheduled
+	//      using the group timer.
+	//
+	// RFC 3810 section 6.2 defines the same rules for MLDv2.
+	delay := g.calculateDelayTimerDuration(maxResponseDelay)
+	deadline := g.opts.Clock.NowMonotonic() + int64(delay)
+	if g.generalQueryV2Pending && g.generalQueryV2Deadline <= deadline {
+		return
+	}
+
+	if groupAddress.Unspecified() {
+		g.generalQueryV2Job.Cancel()
+		g.generalQueryV2Job.Schedule(delay)
+		g.generalQueryV2Deadline = deadline
+		g.generalQueryV2Pending = true
+		return
+	}
+
+	info, ok := g.memberships[groupAddress]
+	if !ok || info.joins == 0 || info.state == nonMember || groupAddress == g.opts.AllNodesAddress {
+		return
+	}
+
+	if info.state.isDelayingMember() {
+		if len(sources) == 0 {
+			info.queriedSources = nil
+		} else if info.queriedSources != nil {
+			for _, src := range sources {
+				info.queriedSources[src] = struct{}{}
+			}
+		}
+	} else {
+		info.queriedSources = nil
+		if len(sources) != 0 {
+			info.queriedSources = make(map[tcpip.Address]struct{})
+			for _, src := range sources {
+				info.queriedSources[src] = struct{}{}
+			}
+		}
+		info.state = delayingMember
+		info.delayedReportJob.Cancel()
+		info.delayedReportJob.Schedule(delay)
+	}
+	g.memberships[groupAddress] = info
+}
+
+// maybeSendGeneralQueryResponseV2Locked attempts to send the response to a
+// version 2 general query.
+//
+// Precondition: g.protocolMU must be locked.
+func (g *GenericMulticastProtocolState) maybeSendGeneralQueryResponseV2Locked() {
+	g.generalQueryV2Queued = false
+	if !g.opts.Protocol.Enabled() || !g.v2ModeLocked() {
+		return
+	}
+
+	var records []MulticastGroupRecord
+	for groupAddress, info := range g.memberships {
+		if info.joins == 0 || info.state == nonMember || groupAddress == g.opts.AllNodesAddress {
+			continue
+		}
+		records = append(records, currentStateRecord(groupAddress, info.filter()))
+	}
+	if len(records) == 0 {
+		return
+	}
+	sort.Slice(records, func(i, j int) bool { return records[i].GroupAddress < records[j].GroupAddress })
+
+	if sent, err := g.opts.ProtocolV2.SendReportV2(records); err != nil || !sent {
+		g.generalQueryV2Queued = true
+	}
+}
+
+// maybeSendGroupQueryResponseV2Locked attempts to send the response to the
+// version 2 group specific or group-and-source specific queries received for a
+// group.
+//
+// Precondition: g.protocolMU must be locked.
+func (g *GenericMulticastProtocolState) maybeSendGroupQueryResponseV2Locked(groupAddress tcpip.Address, info *multicastGroupState) {
+	f := info.filter()
+	record := currentStateRecord(groupAddress, f)
+	if info.queriedSources != nil {
+		// As per RFC 3376 section 5.2 (for IGMPv3) and RFC 3810 section 6.3 (for
+		// MLDv2), the response to a group-and-source specific query lists the
+		// queried sources that packets are accepted from.
+		record.Type = MulticastGroupRecordModeIsInclude
+		record.Sources = nil
+		for src := range info.queriedSources {
+			if f.Allows(src) {
+				record.Sources = append(record.Sources, src)
+			}
+		}
+		sortAddresses(record.Sources)
+	}
+
+	if record.Type == MulticastGroupRecordModeIsInclude && len(record.Sources) == 0 {
+		// There is nothing to report.
+		info.state = idleMember
+		info.queriedSources = nil
+		return
+	}
+
+	sent, err := g.opts.ProtocolV2.SendReportV2([]MulticastGroupRecord{record})
+	if err == nil && sent {
+		info.lastToSendReport = true
+		info.state = idleMember
+		info.queriedSources = nil
+	} else {
+		info.state = queuedDelayingMember
+	}
+}
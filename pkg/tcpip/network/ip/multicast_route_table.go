@@ -0,0 +1,216 @@
+// Copyright 2021 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ip
+
+import (
+	"time"
+
+	"gvisor.dev/gvisor/pkg/sync"
+	"gvisor.dev/gvisor/pkg/tcpip"
+	"gvisor.dev/gvisor/pkg/tcpip/stack"
+)
+
+const (
+	// PendingRouteExpiration is the time after which a pending route, and
+	// the packets queued for it, are discarded if no route was installed for
+	// it.
+	//
+	// This matches Linux's unresolved multicast route cache entries.
+	PendingRouteExpiration = 10 * time.Second
+
+	// MaxPendingRoutes is the maximum number of pending routes. Packets
+	// that would create more pending routes are dropped.
+	MaxPendingRoutes = 10
+
+	// MaxPendingPacketsPerRoute is the maximum number of packets queued for
+	// each pending route.
+	MaxPendingPacketsPerRoute = 3
+)
+
+// pendingRoute holds the packets waiting for a route to be installed.
+type pendingRoute struct {
+	packets []*stack.PacketBuffer
+
+	// expiresAt is the monotonic time after which the pending route is
+	// discarded.
+	expiresAt int64
+}
+
+// MulticastRouteTable is a multicast routing table, as used to forward
+// multicast packets.
+//
+// Routes are installed and removed by a multicast routing daemon, which is
+// notified of the packets that do not match any installed route through a
+// stack.MulticastForwardingEventDispatcher.
+type MulticastRouteTable struct {
+	clock tcpip.Clock
+
+	mu struct {
+		sync.Mutex
+
+		// disp is the dispatcher of multicast forwarding events. It is nil
+		// when multicast forwarding is disabled.
+		disp stack.MulticastForwardingEventDispatcher
+
+		installed map[stack.UnicastSourceAndMulticastDestination]stack.MulticastRoute
+		pending   map[stack.UnicastSourceAndMulticastDestination]*pendingRoute
+	}
+}
+
+// Init initializes the table.
+//
+// Must only be called once for the lifetime of t.
+func (t *MulticastRouteTable) Init(clock tcpip.Clock) {
+	t.clock = clock
+	t.mu.installed = make(map[stack.UnicastSourceAndMulticastDestination]stack.MulticastRoute)
+	t.mu.pending = make(map[stack.UnicastSourceAndMulticastDestination]*pendingRoute)
+}
+
+// Enable enables multicast forwarding, reporting events to disp.
+//
+// Returns true if multicast forwarding was already enabled, in which case disp
+// is ignored.
+func (t *MulticastRouteTable) Enable(disp stack.MulticastForwardingEventDispatcher) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.mu.disp != nil {
+		return true
+	}
+	t.mu.disp = disp
+	return false
+}
+
+// Disable disables multicast forwarding and removes all routes.
+func (t *MulticastRouteTable) Disable() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.mu.disp = nil
+	t.mu.installed = make(map[stack.UnicastSourceAndMulticastDestination]stack.MulticastRoute)
+	t.mu.pending = make(map[stack.UnicastSourceAndMulticastDestination]*pendingRoute)
+}
+
+// Enabled returns true if multicast forwarding is enabled.
+func (t *MulticastRouteTable) Enabled() bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.mu.disp != nil
+}
+
+// AddRoute installs route for addresses, replacing any route previously
+// installed for them.
+//
+// It returns the packets that were pending for the route and arrived on its
+// expected input interface; they should be forwarded by the caller. The other
+// pending packets are dropped.
+func (t *MulticastRouteTable) AddRoute(addresses stack.UnicastSourceAndMulticastDestination, route stack.MulticastRoute) ([]*stack.PacketBuffer, *tcpip.Error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.mu.disp == nil {
+		return nil, tcpip.ErrNotPermitted
+	}
+
+	route.OutgoingInterfaces = append([]stack.MulticastRouteOutgoingInterface(nil), route.OutgoingInterfaces...)
+	t.mu.installed[addresses] = route
+
+	p, ok := t.mu.pending[addresses]
+	if !ok {
+		return nil, nil
+	}
+	delete(t.mu.pending, addresses)
+
+	var pkts []*stack.PacketBuffer
+	for _, pkt := range p.packets {
+		if pkt.NICID == route.ExpectedInputInterface {
+			pkts = append(pkts, pkt)
+		}
+	}
+	return pkts, nil
+}
+
+// RemoveRoute removes the route installed for addresses.
+func (t *MulticastRouteTable) RemoveRoute(addresses stack.UnicastSourceAndMulticastDestination) *tcpip.Error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.mu.disp == nil {
+		return tcpip.ErrNotPermitted
+	}
+	if _, ok := t.mu.installed[addresses]; !ok {
+		return tcpip.ErrNoSuchFile
+	}
+	delete(t.mu.installed, addresses)
+	return nil
+}
+
+// LookupRoute returns the route a multicast packet received on the NIC
+// pkt.NICID should be forwarded with.
+//
+// If no route is installed for the packet, it is queued until one is
+// installed and the dispatcher is notified of the missing route. If the packet
+// arrived on an unexpected interface, it is dropped and the dispatcher is
+// notified. In both cases, false is returned.
+func (t *MulticastRouteTable) LookupRoute(addresses stack.UnicastSourceAndMulticastDestination, pkt *stack.PacketBuffer) (stack.MulticastRoute, bool) {
+	ctx := stack.MulticastPacketContext{
+		SourceAndDestination: addresses,
+		InputInterface:       pkt.NICID,
+	}
+
+	t.mu.Lock()
+	disp := t.mu.disp
+	if disp == nil {
+		t.mu.Unlock()
+		return stack.MulticastRoute{}, false
+	}
+
+	if route, ok := t.mu.installed[addresses]; ok {
+		t.mu.Unlock()
+		if route.ExpectedInputInterface != pkt.NICID {
+			disp.OnUnexpectedInputInterface(ctx, route.ExpectedInputInterface)
+			return stack.MulticastRoute{}, false
+		}
+		return route, true
+	}
+
+	now := t.clock.NowMonotonic()
+	for a, p := range t.mu.pending {
+		if p.expiresAt <= now {
+			delete(t.mu.pending, a)
+		}
+	}
+
+	if p, ok := t.mu.pending[addresses]; ok {
+		if len(p.packets) < MaxPendingPacketsPerRoute {
+			p.packets = append(p.packets, pkt.Clone())
+		}
+		t.mu.Unlock()
+		return stack.MulticastRoute{}, false
+	}
+
+	if len(t.mu.pending) >= MaxPendingRoutes {
+		t.mu.Unlock()
+		return stack.MulticastRoute{}, false
+	}
+	t.mu.pending[addresses] = &pendingRoute{
+		packets:   []*stack.PacketBuffer{pkt.Clone()},
+		expiresAt: now + PendingRouteExpiration.Nanoseconds(),
+	}
+	t.mu.Unlock()
+
+	disp.OnMissingRoute(ctx)
+	return stack.MulticastRoute{}, false
+}
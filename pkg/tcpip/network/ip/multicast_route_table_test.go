@@ -0,0 +1,269 @@
+// Copyright 2021 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ip_test
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"gvisor.dev/gvisor/pkg/tcpip"
+	"gvisor.dev/gvisor/pkg/tcpip/buffer"
+	"gvisor.dev/gvisor/pkg/tcpip/faketime"
+	"gvisor.dev/gvisor/pkg/tcpip/network/ip"
+	"gvisor.dev/gvisor/pkg/tcpip/stack"
+)
+
+const (
+	inputNICID    = 1
+	outgoingNICID = 2
+	otherNICID    = 3
+)
+
+var _ stack.MulticastForwardingEventDispatcher = (*fakeMulticastEventDispatcher)(nil)
+
+type unexpectedInputInterfaceEvent struct {
+	context  stack.MulticastPacketContext
+	expected tcpip.NICID
+}
+
+type fakeMulticastEventDispatcher struct {
+	missingRoutes             []stack.MulticastPacketContext
+	unexpectedInputInterfaces []unexpectedInputInterfaceEvent
+}
+
+func (d *fakeMulticastEventDispatcher) OnMissingRoute(context stack.MulticastPacketContext) {
+	d.missingRoutes = append(d.missingRoutes, context)
+}
+
+func (d *fakeMulticastEventDispatcher) OnUnexpectedInputInterface(context stack.MulticastPacketContext, expected tcpip.NICID) {
+	d.unexpectedInputInterfaces = append(d.unexpectedInputInterfaces, unexpectedInputInterfaceEvent{
+		context:  context,
+		expected: expected,
+	})
+}
+
+func newPacket(nicID tcpip.NICID, payload byte) *stack.PacketBuffer {
+	pkt := stack.NewPacketBuffer(stack.PacketBufferOptions{
+		Data: buffer.View([]byte{payload}).ToVectorisedView(),
+	})
+	pkt.NICID = nicID
+	return pkt
+}
+
+func packetPayloads(pkts []*stack.PacketBuffer) []byte {
+	var payloads []byte
+	for _, pkt := range pkts {
+		payloads = append(payloads, pkt.Data.ToView()...)
+	}
+	return payloads
+}
+
+func newTable(clock tcpip.Clock, disp stack.MulticastForwardingEventDispatcher) *ip.MulticastRouteTable {
+	var table ip.MulticastRouteTable
+	table.Init(clock)
+	if disp != nil {
+		table.Enable(disp)
+	}
+	return &table
+}
+
+var (
+	addresses = stack.UnicastSourceAndMulticastDestination{
+		Source:      addr1,
+		Destination: addr2,
+	}
+
+	route = stack.MulticastRoute{
+		ExpectedInputInterface: inputNICID,
+		OutgoingInterfaces: []stack.MulticastRouteOutgoingInterface{
+			{ID: outgoingNICID, MinTTL: 1},
+		},
+	}
+)
+
+func TestMulticastRouteTableEnable(t *testing.T) {
+	var table ip.MulticastRouteTable
+	table.Init(faketime.NewManualClock())
+
+	if table.Enabled() {
+		t.Fatal("got table.Enabled() = true, want = false")
+	}
+	if _, err := table.AddRoute(addresses, route); err != tcpip.ErrNotPermitted {
+		t.Errorf("got table.AddRoute(%#v, %#v) = %s, want = %s", addresses, route, err, tcpip.ErrNotPermitted)
+	}
+	if _, ok := table.LookupRoute(addresses, newPacket(inputNICID, 0)); ok {
+		t.Error("got table.LookupRoute(...) = (_, true), want = (_, false)")
+	}
+
+	var disp fakeMulticastEventDispatcher
+	if alreadyEnabled := table.Enable(&disp); alreadyEnabled {
+		t.Error("got table.Enable(_) = true, want = false")
+	}
+	if alreadyEnabled := table.Enable(&fakeMulticastEventDispatcher{}); !alreadyEnabled {
+		t.Error("got table.Enable(_) = false, want = true")
+	}
+	if _, err := table.AddRoute(addresses, route); err != nil {
+		t.Fatalf("table.AddRoute(%#v, %#v): %s", addresses, route, err)
+	}
+
+	table.Disable()
+	if table.Enabled() {
+		t.Error("got table.Enabled() = true after disabling, want = false")
+	}
+	table.Enable(&disp)
+	if err := table.RemoveRoute(addresses); err != tcpip.ErrNoSuchFile {
+		t.Errorf("got table.RemoveRoute(%#v) = %s after disabling, want = %s", addresses, err, tcpip.ErrNoSuchFile)
+	}
+}
+
+func TestMulticastRouteTableLookup(t *testing.T) {
+	tests := []struct {
+		name                          string
+		nicID                         tcpip.NICID
+		wantFound                     bool
+		wantUnexpectedInputInterfaces []unexpectedInputInterfaceEvent
+	}{
+		{
+			name:      "Expected input interface",
+			nicID:     inputNICID,
+			wantFound: true,
+		},
+		{
+			name:      "Unexpected input interface",
+			nicID:     otherNICID,
+			wantFound: false,
+			wantUnexpectedInputInterfaces: []unexpectedInputInterfaceEvent{
+				{
+					context: stack.MulticastPacketContext{
+						SourceAndDestination: addresses,
+						InputInterface:       otherNICID,
+					},
+					expected: inputNICID,
+				},
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var disp fakeMulticastEventDispatcher
+			table := newTable(faketime.NewManualClock(), &disp)
+			if _, err := table.AddRoute(addresses, route); err != nil {
+				t.Fatalf("table.AddRoute(%#v, %#v): %s", addresses, route, err)
+			}
+
+			got, ok := table.LookupRoute(addresses, newPacket(test.nicID, 0))
+			if ok != test.wantFound {
+				t.Fatalf("got table.LookupRoute(...) = (_, %t), want = (_, %t)", ok, test.wantFound)
+			}
+			if ok {
+				if diff := cmp.Diff(route, got); diff != "" {
+					t.Errorf("route mismatch (-want +got):\n%s", diff)
+				}
+			}
+			if diff := cmp.Diff(test.wantUnexpectedInputInterfaces, disp.unexpectedInputInterfaces, cmp.AllowUnexported(unexpectedInputInterfaceEvent{})); diff != "" {
+				t.Errorf("unexpected input interface events mismatch (-want +got):\n%s", diff)
+			}
+			if len(disp.missingRoutes) != 0 {
+				t.Errorf("got missing route events = %#v, want = []", disp.missingRoutes)
+			}
+		})
+	}
+}
+
+func TestMulticastRouteTablePendingPackets(t *testing.T) {
+	var disp fakeMulticastEventDispatcher
+	table := newTable(faketime.NewManualClock(), &disp)
+
+	// Only the first packet of a pending route is reported and only
+	// MaxPendingPacketsPerRoute packets are queued.
+	for i := 0; i < ip.MaxPendingPacketsPerRoute+1; i++ {
+		if _, ok := table.LookupRoute(addresses, newPacket(inputNICID, byte(i))); ok {
+			t.Fatalf("got table.LookupRoute(...) = (_, true) for packet %d, want = (_, false)", i)
+		}
+	}
+	// Packets that arrived on an unexpected interface are dropped when the
+	// route is installed.
+	if _, ok := table.LookupRoute(addresses, newPacket(otherNICID, 0xff)); ok {
+		t.Fatal("got table.LookupRoute(...) = (_, true), want = (_, false)")
+	}
+
+	wantMissingRoutes := []stack.MulticastPacketContext{
+		{
+			SourceAndDestination: addresses,
+			InputInterface:       inputNICID,
+		},
+	}
+	if diff := cmp.Diff(wantMissingRoutes, disp.missingRoutes); diff != "" {
+		t.Errorf("missing route events mismatch (-want +got):\n%s", diff)
+	}
+
+	pkts, err := table.AddRoute(addresses, route)
+	if err != nil {
+		t.Fatalf("table.AddRoute(%#v, %#v): %s", addresses, route, err)
+	}
+	wantPayloads := []byte{0, 1, 2}
+	if diff := cmp.Diff(wantPayloads, packetPayloads(pkts)); diff != "" {
+		t.Errorf("pending packets mismatch (-want +got):\n%s", diff)
+	}
+
+	if err := table.RemoveRoute(addresses); err != nil {
+		t.Fatalf("table.RemoveRoute(%#v): %s", addresses, err)
+	}
+	if err := table.RemoveRoute(addresses); err != tcpip.ErrNoSuchFile {
+		t.Errorf("got table.RemoveRoute(%#v) = %s, want = %s", addresses, err, tcpip.ErrNoSuchFile)
+	}
+}
+
+func TestMulticastRouteTablePendingRouteExpiration(t *testing.T) {
+	var disp fakeMulticastEventDispatcher
+	clock := faketime.NewManualClock()
+	table := newTable(clock, &disp)
+
+	table.LookupRoute(addresses, newPacket(inputNICID, 0))
+	clock.Advance(ip.PendingRouteExpiration)
+	table.LookupRoute(addresses, newPacket(inputNICID, 1))
+
+	// The expired pending route is reported again.
+	if got, want := len(disp.missingRoutes), 2; got != want {
+		t.Errorf("got len(disp.missingRoutes) = %d, want = %d", got, want)
+	}
+
+	pkts, err := table.AddRoute(addresses, route)
+	if err != nil {
+		t.Fatalf("table.AddRoute(%#v, %#v): %s", addresses, route, err)
+	}
+	if diff := cmp.Diff([]byte{1}, packetPayloads(pkts)); diff != "" {
+		t.Errorf("pending packets mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestMulticastRouteTableMaxPendingRoutes(t *testing.T) {
+	var disp fakeMulticastEventDispatcher
+	table := newTable(faketime.NewManualClock(), &disp)
+
+	for i := 0; i < ip.MaxPendingRoutes+1; i++ {
+		addresses := stack.UnicastSourceAndMulticastDestination{
+			Source:      addr1,
+			Destination: tcpip.Address(fmt.Sprintf("%c", i)),
+		}
+		table.LookupRoute(addresses, newPacket(inputNICID, 0))
+	}
+
+	if got, want := len(disp.missingRoutes), ip.MaxPendingRoutes; got != want {
+		t.Errorf("got len(disp.missingRoutes) = %d, want = %d", got, want)
+	}
+}
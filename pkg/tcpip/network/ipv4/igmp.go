@@ -48,6 +48,16 @@ const (
 	//
 	// Obtained from RFC 2236 Section 8.10, Page 19.
 	UnsolicitedReportIntervalMax = 10 * time.Second
+
+	// olderVersionQuerierPresentTimeout is the time an interface waits after
+	// hearing an IGMPv2 query before it stops performing IGMPv2 in place of
+	// IGMPv3.
+	//
+	// As per RFC 3376 section 8.12, the Older Version Querier Present Timeout
+	// is the Robustness Variable times the Query Interval plus one Query
+	// Response Interval. The default values of these are 2, 125 seconds and 10
+	// seconds respectively.
+	olderVersionQuerierPresentTimeout = 260 * time.Second
 )
 
 // IGMPOptions holds options for IGMP.
@@ -61,9 +71,18 @@ type IGMPOptions struct {
 	// This field is ignored and is always assumed to be false for interfaces
 	// without neighbouring nodes (e.g. loopback).
 	Enabled bool
+
+	// V3 indicates whether IGMPv3, as defined by RFC 3376, will be performed
+	// in place of IGMPv2 when IGMP is enabled.
+	//
+	// IGMPv3 supports source filters, as used by source-specific multicast.
+	// IGMPv2 is still performed while IGMPv1 or IGMPv2 queriers are present on
+	// the network.
+	V3 bool
 }
 
 var _ ip.MulticastGroupProtocol = (*igmpState)(nil)
+var _ ip.MulticastGroupProtocolV2 = (*igmpState)(nil)
 
 // igmpState is the per-interface IGMP state.
 //
@@ -91,6 +110,17 @@ type igmpState struct {
 	// message, upon expiration the igmpV1Present flag is cleared.
 	// igmpV1Job may not be nil once igmpState is initialized.
 	igmpV1Job *tcpip.Job
+
+	// igmpV2Present is true if an IGMPv2 query was heard in the last
+	// [Older Version Querier Present Timeout], as per RFC 3376 section 7.2.1.
+	//
+	// It is only used when IGMPv3 is enabled.
+	igmpV2Present bool
+
+	// igmpV2Job is scheduled when this interface receives an IGMPv2 query,
+	// upon expiration the igmpV2Present flag is cleared.
+	// igmpV2Job may not be nil once igmpState is initialized.
+	igmpV2Job *tcpip.Job
 }
 
 // Enabled implements ip.MulticastGroupProtocol.
@@ -126,25 +156,69 @@ func (igmp *igmpState) SendLeave(groupAddress tcpip.Address) *tcpip.Error {
 	return err
 }
 
+// SendReportV2 implements ip.MulticastGroupProtocolV2.
+//
+// Precondition: igmp.ep.mu must be read locked.
+func (igmp *igmpState) SendReportV2(records []ip.MulticastGroupRecord) (bool, *tcpip.Error) {
+	// As per RFC 3376 section 4.2.14,
+	//
+	//   Version 3 Reports are sent with an IP destination address of
+	//   224.0.0.22, to which all IGMPv3-capable multicast routers listen.
+	s := header.IGMPv3ReportSerializer{
+		Records: make([]header.IGMPv3ReportGroupAddressRecord, 0, len(records)),
+	}
+	for _, r := range records {
+		s.Records = append(s.Records, header.IGMPv3ReportGroupAddressRecord{
+			RecordType:   header.IGMPv3ReportRecordType(r.Type),
+			GroupAddress: r.GroupAddress,
+			Sources:      r.Sources,
+		})
+	}
+	igmpData := header.IGMP(buffer.NewView(s.Length()))
+	s.SerializeInto(igmpData)
+	igmpData.SetChecksum(header.IGMPCalculateChecksum(igmpData))
+	return igmp.writeIGMPPacket(header.IGMPv3RoutersAddress, igmpData)
+}
+
 // init sets up an igmpState struct, and is required to be called before using
 // a new igmpState.
 //
 // Must only be called once for the lifetime of igmp.
 func (igmp *igmpState) init(ep *endpoint) {
 	igmp.ep = ep
-	igmp.genericMulticastProtocol.Init(&ep.mu.RWMutex, ip.GenericMulticastProtocolOptions{
+	opts := ip.GenericMulticastProtocolOptions{
 		Rand:                      ep.protocol.stack.Rand(),
 		Clock:                     ep.protocol.stack.Clock(),
 		Protocol:                  igmp,
 		MaxUnsolicitedReportDelay: UnsolicitedReportIntervalMax,
 		AllNodesAddress:           header.IPv4AllSystems,
-	})
+	}
+	if ep.protocol.options.IGMP.V3 {
+		opts.ProtocolV2 = igmp
+	}
+	igmp.genericMulticastProtocol.Init(&ep.mu.RWMutex, opts)
 	igmp.igmpV1Present = igmpV1PresentDefault
 	igmp.igmpV1Job = ep.protocol.stack.NewJob(&ep.mu, func() {
 		igmp.setV1Present(false)
+		igmp.updateCompatibilityLocked()
+	})
+	igmp.igmpV2Job = ep.protocol.stack.NewJob(&ep.mu, func() {
+		igmp.igmpV2Present = false
+		igmp.updateCompatibilityLocked()
 	})
 }
 
+// updateCompatibilityLocked updates whether IGMPv2 is performed in place of
+// IGMPv3.
+//
+// As per RFC 3376 section 7.2.1, the host compatibility mode is IGMPv1 or
+// IGMPv2 while queriers running these versions are present.
+//
+// Precondition: igmp.ep.mu must be locked.
+func (igmp *igmpState) updateCompatibilityLocked() {
+	igmp.genericMulticastProtocol.SetV1CompatibilityLocked(igmp.v1Present() || igmp.igmpV2Present)
+}
+
 // handleIGMP handles an IGMP packet.
 //
 // Precondition: igmp.ep.mu must be locked.
@@ -175,6 +249,24 @@ func (igmp *igmpState) handleIGMP(pkt *stack.PacketBuffer) {
 			received.invalid.Increment()
 			return
 		}
+		// As per RFC 3376 section 7.1, queries of at least 12 octets are
+		// IGMPv3 queries. Hosts not performing IGMPv3 handle them as IGMPv2
+		// queries.
+		if igmp.ep.protocol.options.IGMP.V3 && pkt.Data.Size() >= header.IGMPv3QueryMinimumSize {
+			v, ok := pkt.Data.PullUp(pkt.Data.Size())
+			if !ok {
+				received.invalid.Increment()
+				return
+			}
+			q := header.IGMPv3Query(v)
+			sources, ok := q.Sources()
+			if !ok {
+				received.invalid.Increment()
+				return
+			}
+			igmp.handleMembershipQueryV3(q.GroupAddress(), q.MaximumResponseDelay(), sources, q.QuerierRobustnessVariable())
+			return
+		}
 		igmp.handleMembershipQuery(h.GroupAddress(), h.MaxRespTime())
 	case header.IGMPv1MembershipReport:
 		received.v1MembershipReport.Increment()
@@ -190,6 +282,10 @@ func (igmp *igmpState) handleIGMP(pkt *stack.PacketBuffer) {
 			return
 		}
 		igmp.handleMembershipReport(h.GroupAddress())
+	case header.IGMPv3MembershipReport:
+		received.v3MembershipReport.Increment()
+		// As per RFC 3376 section 5.2, IGMPv3 reports of other hosts are
+		// ignored; they are only used by multicast routers.
 	case header.IGMPLeaveGroup:
 		received.leaveGroup.Increment()
 		// As per RFC 2236 Section 6, Page 7: "IGMP messages other than Query or
@@ -227,11 +323,23 @@ func (igmp *igmpState) handleMembershipQuery(groupAddress tcpip.Address, maxResp
 		igmp.igmpV1Job.Schedule(v1RouterPresentTimeout)
 		igmp.setV1Present(true)
 		maxRespTime = v1MaxRespTime
+	} else if igmp.ep.protocol.options.IGMP.V3 && igmp.Enabled() {
+		igmp.igmpV2Job.Cancel()
+		igmp.igmpV2Job.Schedule(olderVersionQuerierPresentTimeout)
+		igmp.igmpV2Present = true
 	}
+	igmp.updateCompatibilityLocked()
 
 	igmp.genericMulticastProtocol.HandleQueryLocked(groupAddress, maxRespTime)
 }
 
+// handleMembershipQueryV3 handles an IGMPv3 membership query.
+//
+// Precondition: igmp.ep.mu must be locked.
+func (igmp *igmpState) handleMembershipQueryV3(groupAddress tcpip.Address, maxRespTime time.Duration, sources []tcpip.Address, robustnessVariable uint8) {
+	igmp.genericMulticastProtocol.HandleQueryV2Locked(groupAddress, maxRespTime, sources, robustnessVariable)
+}
+
 // handleMembershipReport handles a membership report.
 //
 // Precondition: igmp.ep.mu must be locked.
@@ -247,7 +355,13 @@ func (igmp *igmpState) writePacket(destAddress tcpip.Address, groupAddress tcpip
 	igmpData.SetType(igmpType)
 	igmpData.SetGroupAddress(groupAddress)
 	igmpData.SetChecksum(header.IGMPCalculateChecksum(igmpData))
+	return igmp.writeIGMPPacket(destAddress, igmpData)
+}
 
+// writeIGMPPacket sends an IGMP message.
+//
+// Precondition: igmp.ep.mu must be read locked.
+func (igmp *igmpState) writeIGMPPacket(destAddress tcpip.Address, igmpData header.IGMP) (bool, *tcpip.Error) {
 	pkt := stack.NewPacketBuffer(stack.PacketBufferOptions{
 		ReserveHeaderBytes: int(igmp.ep.MaxHeaderLength()),
 		Data:               buffer.View(igmpData).ToVectorisedView(),
@@ -275,11 +389,13 @@ func (igmp *igmpState) writePacket(destAddress tcpip.Address, groupAddress tcpip
 		sentStats.dropped.Increment()
 		return false, err
 	}
-	switch igmpType {
+	switch igmpType := igmpData.Type(); igmpType {
 	case header.IGMPv1MembershipReport:
 		sentStats.v1MembershipReport.Increment()
 	case header.IGMPv2MembershipReport:
 		sentStats.v2MembershipReport.Increment()
+	case header.IGMPv3MembershipReport:
+		sentStats.v3MembershipReport.Increment()
 	case header.IGMPLeaveGroup:
 		sentStats.leaveGroup.Increment()
 	default:
@@ -300,6 +416,20 @@ func (igmp *igmpState) joinGroup(groupAddress tcpip.Address) {
 	igmp.genericMulticastProtocol.JoinGroupLocked(groupAddress)
 }
 
+// changeGroupSourceFilter replaces the source filter of one of the joins of
+// the group, sending and scheduling the required messages.
+//
+// Precondition: igmp.ep.mu must be locked.
+func (igmp *igmpState) changeGroupSourceFilter(groupAddress tcpip.Address, old, new *tcpip.MulticastSourceFilter) *tcpip.Error {
+	// ChangeGroupSourceFilterLocked returns false only if the group was not
+	// joined.
+	if igmp.genericMulticastProtocol.ChangeGroupSourceFilterLocked(groupAddress, old, new) {
+		return nil
+	}
+
+	return tcpip.ErrBadLocalAddress
+}
+
 // isInGroup returns true if the specified group has been joined locally.
 //
 // Precondition: igmp.ep.mu must be read locked.
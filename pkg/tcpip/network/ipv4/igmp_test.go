@@ -18,6 +18,7 @@ import (
 	"testing"
 	"time"
 
+	"github.com/google/go-cmp/cmp"
 	"gvisor.dev/gvisor/pkg/tcpip"
 	"gvisor.dev/gvisor/pkg/tcpip/buffer"
 	"gvisor.dev/gvisor/pkg/tcpip/checker"
@@ -213,3 +214,118 @@ func TestSendQueuedIGMPReports(t *testing.T) {
 		t.Fatalf("got unexpected packet = %#v", p)
 	}
 }
+
+// validateIGMPv3Report checks that a passed PacketInfo is an IPv4 IGMPv3
+// Membership Report holding the passed group records.
+func validateIGMPv3Report(t *testing.T, p channel.PacketInfo, records []header.IGMPv3ReportGroupAddressRecord) {
+	t.Helper()
+
+	payload := header.IPv4(stack.PayloadSince(p.Pkt.NetworkHeader()))
+	checker.IPv4(t, payload,
+		checker.SrcAddr(addr),
+		checker.DstAddr(header.IGMPv3RoutersAddress),
+		// TTL for an IGMP message must be 1 as per RFC 3376 section 4.
+		checker.TTL(1),
+		checker.IPv4RouterAlert(),
+	)
+
+	report := header.IGMPv3Report(payload.Payload())
+	if got := header.IGMP(report).Type(); got != header.IGMPv3MembershipReport {
+		t.Fatalf("got IGMP type = %d, want = %d", got, header.IGMPv3MembershipReport)
+	}
+	got, ok := report.GroupAddressRecords()
+	if !ok {
+		t.Fatal("report.GroupAddressRecords() = (_, false), want = (_, true)")
+	}
+	if diff := cmp.Diff(records, got); diff != "" {
+		t.Errorf("group records mismatch (-want +got):\n%s", diff)
+	}
+}
+
+// TestIGMPv3SourceFilterChanges tests that IGMPv3 State Change Reports are
+// sent, and retransmitted, when the source filter of a group changes.
+func TestIGMPv3SourceFilterChanges(t *testing.T) {
+	const (
+		src1 = tcpip.Address("\x0a\x00\x00\x02")
+		src2 = tcpip.Address("\x0a\x00\x00\x03")
+	)
+
+	e := channel.New(1, 1280, linkAddr)
+	clock := faketime.NewManualClock()
+	s := stack.New(stack.Options{
+		NetworkProtocols: []stack.NetworkProtocolFactory{ipv4.NewProtocolWithOptions(ipv4.Options{
+			IGMP: ipv4.IGMPOptions{
+				Enabled: true,
+				V3:      true,
+			},
+		})},
+		Clock: clock,
+	})
+	if err := s.CreateNIC(nicID, e); err != nil {
+		t.Fatalf("CreateNIC(%d, _) = %s", nicID, err)
+	}
+	if err := s.AddAddress(nicID, ipv4.ProtocolNumber, addr); err != nil {
+		t.Fatalf("AddAddress(%d, %d, %s): %s", nicID, ipv4.ProtocolNumber, addr, err)
+	}
+
+	readReports := func(records []header.IGMPv3ReportGroupAddressRecord) {
+		t.Helper()
+
+		// As per RFC 3376 section 5.1, State Change Reports are
+		// retransmitted [Robustness Variable] times.
+		for i := 0; i < 2; i++ {
+			if i != 0 {
+				clock.Advance(time.Second)
+			}
+			p, ok := e.Read()
+			if !ok {
+				t.Fatalf("unable to Read IGMPv3 report #%d", i)
+			}
+			validateIGMPv3Report(t, p, records)
+		}
+		clock.Advance(time.Hour)
+		if p, ok := e.Read(); ok {
+			t.Fatalf("got unexpected packet = %#v", p)
+		}
+	}
+
+	filter := tcpip.MulticastSourceFilter{Mode: tcpip.MulticastFilterInclude, Sources: []tcpip.Address{src1}}
+	if err := s.ChangeGroupSourceFilter(ipv4.ProtocolNumber, nicID, multicastAddr, nil, &filter); err != nil {
+		t.Fatalf("ChangeGroupSourceFilter(ipv4, %d, %s, nil, %#v) = %s", nicID, multicastAddr, filter, err)
+	}
+	readReports([]header.IGMPv3ReportGroupAddressRecord{{
+		RecordType:   header.IGMPv3ReportRecordAllowNewSources,
+		GroupAddress: multicastAddr,
+		Sources:      []tcpip.Address{src1},
+	}})
+
+	newFilter := tcpip.MulticastSourceFilter{Mode: tcpip.MulticastFilterInclude, Sources: []tcpip.Address{src2}}
+	if err := s.ChangeGroupSourceFilter(ipv4.ProtocolNumber, nicID, multicastAddr, &filter, &newFilter); err != nil {
+		t.Fatalf("ChangeGroupSourceFilter(ipv4, %d, %s, %#v, %#v) = %s", nicID, multicastAddr, filter, newFilter, err)
+	}
+	readReports([]header.IGMPv3ReportGroupAddressRecord{
+		{
+			RecordType:   header.IGMPv3ReportRecordAllowNewSources,
+			GroupAddress: multicastAddr,
+			Sources:      []tcpip.Address{src2},
+		},
+		{
+			RecordType:   header.IGMPv3ReportRecordBlockOldSources,
+			GroupAddress: multicastAddr,
+			Sources:      []tcpip.Address{src1},
+		},
+	})
+
+	if err := s.ChangeGroupSourceFilter(ipv4.ProtocolNumber, nicID, multicastAddr, &newFilter, nil); err != nil {
+		t.Fatalf("ChangeGroupSourceFilter(ipv4, %d, %s, %#v, nil) = %s", nicID, multicastAddr, newFilter, err)
+	}
+	readReports([]header.IGMPv3ReportGroupAddressRecord{{
+		RecordType:   header.IGMPv3ReportRecordBlockOldSources,
+		GroupAddress: multicastAddr,
+		Sources:      []tcpip.Address{src2},
+	}})
+
+	if got := s.Stats().IGMP.PacketsSent.V3MembershipReport.Value(); got != 6 {
+		t.Errorf("got V3MembershipReport messages sent = %d, want = 6", got)
+	}
+}
@@ -29,6 +29,7 @@ import (
 	"gvisor.dev/gvisor/pkg/tcpip/header/parse"
 	"gvisor.dev/gvisor/pkg/tcpip/network/fragmentation"
 	"gvisor.dev/gvisor/pkg/tcpip/network/hash"
+	"gvisor.dev/gvisor/pkg/tcpip/network/ip"
 	"gvisor.dev/gvisor/pkg/tcpip/stack"
 )
 
@@ -572,7 +573,10 @@ func (e *endpoint) forwardPacket(pkt *stack.PacketBuffer) *tcpip.Error {
 		return err
 	}
 
-	r, err := e.protocol.stack.FindRoute(0, "", dstAddr, ProtocolNumber, false /* multicastLoop */)
+	r, err := e.protocol.stack.FindRouteWithOptions(0, "", dstAddr, ProtocolNumber, false /* multicastLoop */, stack.RouteLookupOptions{
+		InputNIC: e.nic.ID(),
+		Source:   h.SourceAddress(),
+	})
 	if err != nil {
 		return err
 	}
@@ -597,6 +601,53 @@ func (e *endpoint) forwardPacket(pkt *stack.PacketBuffer) *tcpip.Error {
 	}))
 }
 
+// forwardMulticastPacket attempts to forward a multicast packet through the
+// multicast route installed for its addresses.
+func (e *endpoint) forwardMulticastPacket(h header.IPv4, pkt *stack.PacketBuffer) {
+	addresses := stack.UnicastSourceAndMulticastDestination{
+		Source:      h.SourceAddress(),
+		Destination: h.DestinationAddress(),
+	}
+	if route, ok := e.protocol.multicastRouteTable.LookupRoute(addresses, pkt); ok {
+		e.protocol.forwardValidatedMulticastPacket(pkt, route)
+	}
+}
+
+// forwardValidatedMulticastPacket forwards a multicast packet through each of
+// the outgoing interfaces of route.
+//
+// The packet must have arrived on the expected input interface of route.
+func (p *protocol) forwardValidatedMulticastPacket(pkt *stack.PacketBuffer, route stack.MulticastRoute) {
+	h := header.IPv4(pkt.NetworkHeader().View())
+	ttl := h.TTL()
+
+	for _, outgoingInterface := range route.OutgoingInterfaces {
+		// As per RFC 1812 section 5.2.1.3, a multicast packet is only
+		// forwarded out of an interface if its TTL is greater than the
+		// interface's threshold.
+		if ttl <= 1 || ttl < outgoingInterface.MinTTL {
+			continue
+		}
+
+		r, err := p.stack.FindRoute(outgoingInterface.ID, "", h.DestinationAddress(), ProtocolNumber, false /* multicastLoop */)
+		if err != nil {
+			continue
+		}
+
+		// We need to do a deep copy of the IP packet for each interface because
+		// WriteHeaderIncludedPacket takes ownership of the packet buffer, but we
+		// do not own it.
+		newHdr := header.IPv4(stack.PayloadSince(pkt.NetworkHeader()))
+		newHdr.SetTTL(ttl - 1)
+
+		_ = r.WriteHeaderIncludedPacket(stack.NewPacketBuffer(stack.PacketBufferOptions{
+			ReserveHeaderBytes: int(r.MaxHeaderLength()),
+			Data:               buffer.View(newHdr).ToVectorisedView(),
+		}))
+		r.Release()
+	}
+}
+
 // HandlePacket is called by the link layer when new ipv4 packets arrive for
 // this endpoint.
 func (e *endpoint) HandlePacket(pkt *stack.PacketBuffer) {
@@ -687,6 +738,20 @@ func (e *endpoint) handlePacket(pkt *stack.PacketBuffer) {
 		subnet := addressEndpoint.AddressWithPrefix().Subnet()
 		addressEndpoint.DecRef()
 		pkt.NetworkPacketInfo.LocalAddressBroadcast = subnet.IsBroadcast(dstAddr) || dstAddr == header.IPv4Broadcast
+	} else if pkt.TProxy.Port != 0 {
+		// The packet was diverted to a transparent proxy by the TPROXY
+		// target, it is delivered locally.
+	} else if header.IsV4MulticastAddress(dstAddr) && e.protocol.multicastRouteTable.Enabled() {
+		// As per RFC 5771 section 4, packets sent to the Local Network Control
+		// Block are not forwarded.
+		if !header.IsV4LinkLocalMulticastAddress(dstAddr) {
+			e.forwardMulticastPacket(h, pkt)
+		}
+
+		// The packet is still delivered locally if the group was joined.
+		if !e.IsInGroup(dstAddr) {
+			return
+		}
 	} else if !e.IsInGroup(dstAddr) {
 		if !e.protocol.Forwarding() {
 			stats.ip.InvalidDestinationAddressesReceived.Increment()
@@ -772,6 +837,9 @@ func (e *endpoint) handlePacket(pkt *stack.PacketBuffer) {
 		return
 	}
 	if p == header.IGMPProtocolNumber {
+		// IGMP packets are handled by the endpoint, but raw IGMP endpoints
+		// (e.g. of multicast routing daemons) also receive them.
+		e.protocol.stack.DeliverRawPacket(p, pkt)
 		e.mu.Lock()
 		e.mu.igmp.handleIGMP(pkt)
 		e.mu.Unlock()
@@ -926,6 +994,17 @@ func (e *endpoint) leaveGroupLocked(addr tcpip.Address) *tcpip.Error {
 	return e.mu.igmp.leaveGroup(addr)
 }
 
+// ChangeGroupSourceFilter implements stack.GroupAddressableEndpoint.
+func (e *endpoint) ChangeGroupSourceFilter(addr tcpip.Address, old, new *tcpip.MulticastSourceFilter) *tcpip.Error {
+	if !header.IsV4MulticastAddress(addr) {
+		return tcpip.ErrBadAddress
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.mu.igmp.changeGroupSourceFilter(addr, old, new)
+}
+
 // IsInGroup implements stack.GroupAddressableEndpoint.
 func (e *endpoint) IsInGroup(addr tcpip.Address) bool {
 	e.mu.RLock()
@@ -939,6 +1018,7 @@ func (e *endpoint) Stats() stack.NetworkEndpointStats {
 }
 
 var _ stack.ForwardingNetworkProtocol = (*protocol)(nil)
+var _ stack.MulticastForwardingNetworkProtocol = (*protocol)(nil)
 var _ stack.NetworkProtocol = (*protocol)(nil)
 var _ fragmentation.TimeoutHandler = (*protocol)(nil)
 
@@ -970,6 +1050,9 @@ type protocol struct {
 
 	fragmentation *fragmentation.Fragmentation
 
+	// multicastRouteTable holds the routes used to forward multicast packets.
+	multicastRouteTable ip.MulticastRouteTable
+
 	options Options
 }
 
@@ -1056,6 +1139,54 @@ func (p *protocol) SetForwarding(v bool) {
 	}
 }
 
+// EnableMulticastForwarding implements
+// stack.MulticastForwardingNetworkProtocol.
+func (p *protocol) EnableMulticastForwarding(disp stack.MulticastForwardingEventDispatcher) bool {
+	return p.multicastRouteTable.Enable(disp)
+}
+
+// DisableMulticastForwarding implements
+// stack.MulticastForwardingNetworkProtocol.
+func (p *protocol) DisableMulticastForwarding() {
+	p.multicastRouteTable.Disable()
+}
+
+// AddMulticastRoute implements stack.MulticastForwardingNetworkProtocol.
+func (p *protocol) AddMulticastRoute(addresses stack.UnicastSourceAndMulticastDestination, route stack.MulticastRoute) *tcpip.Error {
+	if err := validateMulticastRouteAddresses(addresses); err != nil {
+		return err
+	}
+
+	pkts, err := p.multicastRouteTable.AddRoute(addresses, route)
+	if err != nil {
+		return err
+	}
+	for _, pkt := range pkts {
+		p.forwardValidatedMulticastPacket(pkt, route)
+	}
+	return nil
+}
+
+// RemoveMulticastRoute implements stack.MulticastForwardingNetworkProtocol.
+func (p *protocol) RemoveMulticastRoute(addresses stack.UnicastSourceAndMulticastDestination) *tcpip.Error {
+	if err := validateMulticastRouteAddresses(addresses); err != nil {
+		return err
+	}
+	return p.multicastRouteTable.RemoveRoute(addresses)
+}
+
+// validateMulticastRouteAddresses returns an error if addresses are not a
+// unicast IPv4 source address and a multicast IPv4 destination address.
+func validateMulticastRouteAddresses(addresses stack.UnicastSourceAndMulticastDestination) *tcpip.Error {
+	if len(addresses.Source) != header.IPv4AddressSize || header.IsV4MulticastAddress(addresses.Source) || addresses.Source == header.IPv4Broadcast {
+		return tcpip.ErrBadAddress
+	}
+	if !header.IsV4MulticastAddress(addresses.Destination) || header.IsV4LinkLocalMulticastAddress(addresses.Destination) {
+		return tcpip.ErrBadAddress
+	}
+	return nil
+}
+
 // calculateNetworkMTU calculates the network-layer payload MTU based on the
 // link-layer payload mtu.
 func calculateNetworkMTU(linkMTU, networkHeaderSize uint32) (uint32, *tcpip.Error) {
@@ -1131,6 +1262,7 @@ func NewProtocolWithOptions(opts Options) stack.NetworkProtocolFactory {
 		}
 		p.fragmentation = fragmentation.NewFragmentation(fragmentblockSize, fragmentation.HighFragThreshold, fragmentation.LowFragThreshold, ReassembleTimeout, s.Clock(), p)
 		p.mu.eps = make(map[tcpip.NICID]*endpoint)
+		p.multicastRouteTable.Init(s.Clock())
 		return p
 	}
 }
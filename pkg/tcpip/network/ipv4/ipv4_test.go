@@ -262,6 +262,211 @@ func TestForwarding(t *testing.T) {
 	}
 }
 
+var _ stack.MulticastForwardingEventDispatcher = (*fakeMulticastEventDispatcher)(nil)
+
+type fakeMulticastEventDispatcher struct {
+	missingRoutes             []stack.MulticastPacketContext
+	unexpectedInputInterfaces []stack.MulticastPacketContext
+}
+
+func (d *fakeMulticastEventDispatcher) OnMissingRoute(context stack.MulticastPacketContext) {
+	d.missingRoutes = append(d.missingRoutes, context)
+}
+
+func (d *fakeMulticastEventDispatcher) OnUnexpectedInputInterface(context stack.MulticastPacketContext, _ tcpip.NICID) {
+	d.unexpectedInputInterfaces = append(d.unexpectedInputInterfaces, context)
+}
+
+func TestMulticastForwarding(t *testing.T) {
+	const (
+		incomingNICID = 1
+		outgoingNICID = 2
+		otherNICID    = 3
+	)
+
+	remoteAddr := tcpip.Address(net.ParseIP("10.0.0.2").To4())
+	groupAddr := tcpip.Address(net.ParseIP("225.0.0.1").To4())
+	linkLocalGroupAddr := tcpip.Address(net.ParseIP("224.0.0.5").To4())
+	addresses := stack.UnicastSourceAndMulticastDestination{
+		Source:      remoteAddr,
+		Destination: groupAddr,
+	}
+	wantContext := func(nicID tcpip.NICID) []stack.MulticastPacketContext {
+		return []stack.MulticastPacketContext{
+			{
+				SourceAndDestination: addresses,
+				InputInterface:       nicID,
+			},
+		}
+	}
+
+	tests := []struct {
+		name                          string
+		ttl                           uint8
+		minTTL                        uint8
+		dstAddr                       tcpip.Address
+		inputNICID                    tcpip.NICID
+		addRouteAfterPacket           bool
+		wantForwarded                 bool
+		wantMissingRoutes             []stack.MulticastPacketContext
+		wantUnexpectedInputInterfaces []stack.MulticastPacketContext
+	}{
+		{
+			name:          "Forwarded",
+			ttl:           2,
+			minTTL:        1,
+			dstAddr:       groupAddr,
+			inputNICID:    incomingNICID,
+			wantForwarded: true,
+		},
+		{
+			name:          "TTL of one",
+			ttl:           1,
+			minTTL:        1,
+			dstAddr:       groupAddr,
+			inputNICID:    incomingNICID,
+			wantForwarded: false,
+		},
+		{
+			name:          "TTL below threshold",
+			ttl:           2,
+			minTTL:        3,
+			dstAddr:       groupAddr,
+			inputNICID:    incomingNICID,
+			wantForwarded: false,
+		},
+		{
+			name:          "Link-local group",
+			ttl:           2,
+			minTTL:        1,
+			dstAddr:       linkLocalGroupAddr,
+			inputNICID:    incomingNICID,
+			wantForwarded: false,
+		},
+		{
+			name:                          "Unexpected input interface",
+			ttl:                           2,
+			minTTL:                        1,
+			dstAddr:                       groupAddr,
+			inputNICID:                    otherNICID,
+			wantForwarded:                 false,
+			wantUnexpectedInputInterfaces: wantContext(otherNICID),
+		},
+		{
+			name:                "Pending packet",
+			ttl:                 2,
+			minTTL:              1,
+			dstAddr:             groupAddr,
+			inputNICID:          incomingNICID,
+			addRouteAfterPacket: true,
+			wantForwarded:       true,
+			wantMissingRoutes:   wantContext(incomingNICID),
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			s := stack.New(stack.Options{
+				NetworkProtocols: []stack.NetworkProtocolFactory{ipv4.NewProtocol},
+			})
+
+			endpoints := make(map[tcpip.NICID]*channel.Endpoint)
+			for i, nicID := range []tcpip.NICID{incomingNICID, outgoingNICID, otherNICID} {
+				e := channel.New(1, ipv4.MaxTotalSize, "")
+				if err := s.CreateNIC(nicID, e); err != nil {
+					t.Fatalf("CreateNIC(%d, _): %s", nicID, err)
+				}
+				protocolAddr := tcpip.ProtocolAddress{
+					Protocol: header.IPv4ProtocolNumber,
+					AddressWithPrefix: tcpip.AddressWithPrefix{
+						Address:   tcpip.Address(net.IPv4(10, 0, byte(i), 1).To4()),
+						PrefixLen: 24,
+					},
+				}
+				if err := s.AddProtocolAddress(nicID, protocolAddr); err != nil {
+					t.Fatalf("AddProtocolAddress(%d, %#v): %s", nicID, protocolAddr, err)
+				}
+				endpoints[nicID] = e
+			}
+
+			var disp fakeMulticastEventDispatcher
+			if _, err := s.EnableMulticastForwardingForProtocol(header.IPv4ProtocolNumber, &disp); err != nil {
+				t.Fatalf("s.EnableMulticastForwardingForProtocol(%d, _): %s", header.IPv4ProtocolNumber, err)
+			}
+
+			route := stack.MulticastRoute{
+				ExpectedInputInterface: incomingNICID,
+				OutgoingInterfaces: []stack.MulticastRouteOutgoingInterface{
+					{ID: outgoingNICID, MinTTL: test.minTTL},
+				},
+			}
+			routeAddresses := stack.UnicastSourceAndMulticastDestination{
+				Source:      remoteAddr,
+				Destination: test.dstAddr,
+			}
+			addRoute := func() {
+				err := s.AddMulticastRoute(header.IPv4ProtocolNumber, routeAddresses, route)
+				if header.IsV4LinkLocalMulticastAddress(test.dstAddr) {
+					if err != tcpip.ErrBadAddress {
+						t.Fatalf("got s.AddMulticastRoute(%d, %#v, %#v) = %s, want = %s", header.IPv4ProtocolNumber, routeAddresses, route, err, tcpip.ErrBadAddress)
+					}
+					return
+				}
+				if err != nil {
+					t.Fatalf("s.AddMulticastRoute(%d, %#v, %#v): %s", header.IPv4ProtocolNumber, routeAddresses, route, err)
+				}
+			}
+			if !test.addRouteAfterPacket {
+				addRoute()
+			}
+
+			totalLen := uint16(header.IPv4MinimumSize + header.UDPMinimumSize)
+			hdr := buffer.NewPrependable(int(totalLen))
+			hdr.Prepend(header.UDPMinimumSize)
+			ip := header.IPv4(hdr.Prepend(header.IPv4MinimumSize))
+			ip.Encode(&header.IPv4Fields{
+				TotalLength: totalLen,
+				Protocol:    uint8(header.UDPProtocolNumber),
+				TTL:         test.ttl,
+				SrcAddr:     remoteAddr,
+				DstAddr:     test.dstAddr,
+			})
+			ip.SetChecksum(^ip.CalculateChecksum())
+			endpoints[test.inputNICID].InjectInbound(header.IPv4ProtocolNumber, stack.NewPacketBuffer(stack.PacketBufferOptions{
+				Data: hdr.View().ToVectorisedView(),
+			}))
+
+			if test.addRouteAfterPacket {
+				addRoute()
+			}
+
+			p, ok := endpoints[outgoingNICID].Read()
+			if ok != test.wantForwarded {
+				t.Fatalf("got endpoints[%d].Read() = (_, %t), want = (_, %t)", outgoingNICID, ok, test.wantForwarded)
+			}
+			if ok {
+				checker.IPv4(t, header.IPv4(stack.PayloadSince(p.Pkt.NetworkHeader())),
+					checker.SrcAddr(remoteAddr),
+					checker.DstAddr(test.dstAddr),
+					checker.TTL(test.ttl-1),
+				)
+			}
+			for _, nicID := range []tcpip.NICID{incomingNICID, otherNICID} {
+				if n := endpoints[nicID].Drain(); n != 0 {
+					t.Errorf("got endpoints[%d].Drain() = %d, want = 0", nicID, n)
+				}
+			}
+
+			if diff := cmp.Diff(test.wantMissingRoutes, disp.missingRoutes); diff != "" {
+				t.Errorf("missing route events mismatch (-want +got):\n%s", diff)
+			}
+			if diff := cmp.Diff(test.wantUnexpectedInputInterfaces, disp.unexpectedInputInterfaces); diff != "" {
+				t.Errorf("unexpected input interface events mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
 // TestIPv4Sanity sends IP/ICMP packets with various problems to the stack and
 // checks the response.
 func TestIPv4Sanity(t *testing.T) {
@@ -663,7 +663,14 @@ func (e *endpoint) handleICMP(pkt *stack.PacketBuffer, hasFragmentHeader bool) {
 		switch icmpType {
 		case header.ICMPv6MulticastListenerQuery:
 			e.mu.Lock()
-			e.mu.mld.handleMulticastListenerQuery(header.MLD(payload.ToView()))
+			// As per RFC 3810 section 8.1, queries with a body of at least 24
+			// octets are MLDv2 queries. Nodes not performing MLDv2 handle them as
+			// MLDv1 queries.
+			if e.protocol.options.MLD.V2 && payload.Size() >= header.MLDv2QueryMinimumSize {
+				e.mu.mld.handleMulticastListenerQueryV2(header.MLDv2Query(payload.ToView()))
+			} else {
+				e.mu.mld.handleMulticastListenerQuery(header.MLD(payload.ToView()))
+			}
 			e.mu.Unlock()
 		case header.ICMPv6MulticastListenerReport:
 			e.mu.Lock()
@@ -674,6 +681,15 @@ func (e *endpoint) handleICMP(pkt *stack.PacketBuffer, hasFragmentHeader bool) {
 			panic(fmt.Sprintf("unrecognized MLD message = %d", icmpType))
 		}
 
+	case header.ICMPv6MulticastListenerV2Report:
+		received.multicastListenerReportV2.Increment()
+		if payload.Size() < header.MLDv2ReportMinimumSize {
+			received.invalid.Increment()
+			return
+		}
+		// As per RFC 3810 section 6.2, MLDv2 reports of other nodes are ignored;
+		// they are only used by multicast routers.
+
 	default:
 		received.unrecognized.Increment()
 	}
@@ -286,6 +286,10 @@ func TestICMPCounts(t *testing.T) {
 					typ:  header.ICMPv6MulticastListenerDone,
 					size: header.MLDMinimumSize + header.ICMPv6HeaderSize,
 				},
+				{
+					typ:  header.ICMPv6MulticastListenerV2Report,
+					size: header.MLDv2ReportMinimumSize + header.ICMPv6HeaderSize,
+				},
 				{
 					typ:  255, /* Unrecognized */
 					size: 50,
@@ -444,6 +448,10 @@ func TestICMPCountsWithNeighborCache(t *testing.T) {
 			typ:  header.ICMPv6MulticastListenerDone,
 			size: header.MLDMinimumSize + header.ICMPv6HeaderSize,
 		},
+		{
+			typ:  header.ICMPv6MulticastListenerV2Report,
+			size: header.MLDv2ReportMinimumSize + header.ICMPv6HeaderSize,
+		},
 		{
 			typ:  255, /* Unrecognized */
 			size: 50,
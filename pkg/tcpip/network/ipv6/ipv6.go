@@ -32,6 +32,7 @@ import (
 	"gvisor.dev/gvisor/pkg/tcpip/header/parse"
 	"gvisor.dev/gvisor/pkg/tcpip/network/fragmentation"
 	"gvisor.dev/gvisor/pkg/tcpip/network/hash"
+	"gvisor.dev/gvisor/pkg/tcpip/network/ip"
 	"gvisor.dev/gvisor/pkg/tcpip/stack"
 )
 
@@ -154,14 +155,44 @@ var policyTable = [...]struct {
 	},
 }
 
-func getLabel(addr tcpip.Address) uint8 {
+// defaultAddressLabel is the label of addresses that do not match any entry of
+// the policy table.
+const defaultAddressLabel = 0xffffffff
+
+// defaultAddressLabels returns the policy table entries of policyTable.
+func defaultAddressLabels() []stack.AddressLabel {
+	labels := make([]stack.AddressLabel, 0, len(policyTable))
 	for _, p := range policyTable {
-		if p.subnet.Contains(addr) {
-			return p.label
-		}
+		labels = append(labels, stack.AddressLabel{
+			Prefix: p.subnet,
+			Label:  uint32(p.label),
+		})
 	}
+	return labels
+}
 
-	panic(fmt.Sprintf("should have a label for address = %s", addr))
+// sortAddressLabels sorts labels so that the first entry matching an address
+// is the most specific one: longest prefixes first and, for the same prefix
+// length, entries restricted to a NIC first.
+func sortAddressLabels(labels []stack.AddressLabel) {
+	sort.SliceStable(labels, func(i, j int) bool {
+		if li, lj := labels[i].Prefix.Prefix(), labels[j].Prefix.Prefix(); li != lj {
+			return li > lj
+		}
+		return labels[i].NIC != 0 && labels[j].NIC == 0
+	})
+}
+
+// addressLabel returns the label of addr when used on the NIC nicID.
+func (p *protocol) addressLabel(addr tcpip.Address, nicID tcpip.NICID) uint32 {
+	p.addressLabels.RLock()
+	defer p.addressLabels.RUnlock()
+	for _, l := range p.addressLabels.labels {
+		if (l.NIC == 0 || l.NIC == nicID) && l.Prefix.Contains(addr) {
+			return l.Label
+		}
+	}
+	return defaultAddressLabel
 }
 
 var _ stack.LinkResolvableNetworkEndpoint = (*endpoint)(nil)
@@ -285,6 +316,13 @@ func (e *endpoint) InvalidateDefaultRouter(rtr tcpip.Address) {
 	e.mu.ndp.invalidateDefaultRouter(rtr)
 }
 
+// NDPConfigurations implements NDPEndpoint.
+func (e *endpoint) NDPConfigurations() NDPConfigurations {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	return e.mu.ndp.configs
+}
+
 // SetNDPConfigurations implements NDPEndpoint.
 func (e *endpoint) SetNDPConfigurations(c NDPConfigurations) {
 	c.validate()
@@ -860,7 +898,10 @@ func (e *endpoint) forwardPacket(pkt *stack.PacketBuffer) *tcpip.Error {
 		return err
 	}
 
-	r, err := e.protocol.stack.FindRoute(0, "", dstAddr, ProtocolNumber, false /* multicastLoop */)
+	r, err := e.protocol.stack.FindRouteWithOptions(0, "", dstAddr, ProtocolNumber, false /* multicastLoop */, stack.RouteLookupOptions{
+		InputNIC: e.nic.ID(),
+		Source:   h.SourceAddress(),
+	})
 	if err != nil {
 		return err
 	}
@@ -883,6 +924,52 @@ func (e *endpoint) forwardPacket(pkt *stack.PacketBuffer) *tcpip.Error {
 	}))
 }
 
+// forwardMulticastPacket attempts to forward a multicast packet through the
+// multicast route installed for its addresses.
+func (e *endpoint) forwardMulticastPacket(h header.IPv6, pkt *stack.PacketBuffer) {
+	addresses := stack.UnicastSourceAndMulticastDestination{
+		Source:      h.SourceAddress(),
+		Destination: h.DestinationAddress(),
+	}
+	if route, ok := e.protocol.multicastRouteTable.LookupRoute(addresses, pkt); ok {
+		e.protocol.forwardValidatedMulticastPacket(pkt, route)
+	}
+}
+
+// forwardValidatedMulticastPacket forwards a multicast packet through each of
+// the outgoing interfaces of route.
+//
+// The packet must have arrived on the expected input interface of route.
+func (p *protocol) forwardValidatedMulticastPacket(pkt *stack.PacketBuffer, route stack.MulticastRoute) {
+	h := header.IPv6(pkt.NetworkHeader().View())
+	hopLimit := h.HopLimit()
+
+	for _, outgoingInterface := range route.OutgoingInterfaces {
+		// A multicast packet is only forwarded out of an interface if its hop
+		// limit is greater than the interface's threshold.
+		if hopLimit <= 1 || hopLimit < outgoingInterface.MinTTL {
+			continue
+		}
+
+		r, err := p.stack.FindRoute(outgoingInterface.ID, "", h.DestinationAddress(), ProtocolNumber, false /* multicastLoop */)
+		if err != nil {
+			continue
+		}
+
+		// We need to do a deep copy of the IP packet for each interface because
+		// WriteHeaderIncludedPacket takes ownership of the packet buffer, but we
+		// do not own it.
+		newHdr := header.IPv6(stack.PayloadSince(pkt.NetworkHeader()))
+		newHdr.SetHopLimit(hopLimit - 1)
+
+		_ = r.WriteHeaderIncludedPacket(stack.NewPacketBuffer(stack.PacketBufferOptions{
+			ReserveHeaderBytes: int(r.MaxHeaderLength()),
+			Data:               buffer.View(newHdr).ToVectorisedView(),
+		}))
+		r.Release()
+	}
+}
+
 // HandlePacket is called by the link layer when new ipv6 packets arrive for
 // this endpoint.
 func (e *endpoint) HandlePacket(pkt *stack.PacketBuffer) {
@@ -934,6 +1021,21 @@ func (e *endpoint) handlePacket(pkt *stack.PacketBuffer) {
 	// for us to receive the packet. Otherwise, attempt to forward the packet.
 	if addressEndpoint := e.AcquireAssignedAddress(dstAddr, e.nic.Promiscuous(), stack.CanBePrimaryEndpoint); addressEndpoint != nil {
 		addressEndpoint.DecRef()
+	} else if pkt.TProxy.Port != 0 {
+		// The packet was diverted to a transparent proxy by the TPROXY
+		// target, it is delivered locally.
+	} else if header.IsV6MulticastAddress(dstAddr) && e.protocol.multicastRouteTable.Enabled() {
+		// As per RFC 4291 section 2.7, packets sent to interface-local and
+		// link-local multicast addresses are not forwarded. As per RFC 4007
+		// section 9, neither are packets with a link-local source address.
+		if header.V6MulticastScope(dstAddr) > header.IPv6LinkLocalMulticastScope && !header.IsV6LinkLocalAddress(srcAddr) {
+			e.forwardMulticastPacket(h, pkt)
+		}
+
+		// The packet is still delivered locally if the group was joined.
+		if !e.IsInGroup(dstAddr) {
+			return
+		}
 	} else if !e.IsInGroup(dstAddr) {
 		if !e.protocol.Forwarding() {
 			stats.InvalidDestinationAddressesReceived.Increment()
@@ -1503,7 +1605,7 @@ func (e *endpoint) acquireOutgoingPrimaryAddressRLocked(remoteAddr tcpip.Address
 		addr            tcpip.Address
 		scope           header.IPv6AddressScope
 
-		label          uint8
+		label          uint32
 		matchingPrefix uint8
 	}
 
@@ -1533,7 +1635,7 @@ func (e *endpoint) acquireOutgoingPrimaryAddressRLocked(remoteAddr tcpip.Address
 			addressEndpoint: addressEndpoint,
 			addr:            addr,
 			scope:           scope,
-			label:           getLabel(addr),
+			label:           e.protocol.addressLabel(addr, e.nic.ID()),
 			matchingPrefix:  remoteAddr.MatchingPrefix(addr),
 		})
 
@@ -1546,7 +1648,7 @@ func (e *endpoint) acquireOutgoingPrimaryAddressRLocked(remoteAddr tcpip.Address
 		panic(fmt.Sprintf("header.ScopeForIPv6Address(%s): %s", remoteAddr, err))
 	}
 
-	remoteLabel := getLabel(remoteAddr)
+	remoteLabel := e.protocol.addressLabel(remoteAddr, e.nic.ID())
 
 	// Sort the addresses as per RFC 6724 section 5 rules 1-3.
 	//
@@ -1586,9 +1688,10 @@ func (e *endpoint) acquireOutgoingPrimaryAddressRLocked(remoteAddr tcpip.Address
 			}
 		}
 
-		// Prefer temporary addresses as per RFC 6724 section 5 rule 7.
+		// Prefer temporary addresses as per RFC 6724 section 5 rule 7, unless
+		// the preference is reversed.
 		if saTemp, sbTemp := sa.addressEndpoint.ConfigType() == stack.AddressConfigSlaacTemp, sb.addressEndpoint.ConfigType() == stack.AddressConfigSlaacTemp; saTemp != sbTemp {
-			return saTemp
+			return saTemp != e.mu.ndp.configs.PreferPublicAddresses
 		}
 
 		// Use longest matching prefix as per RFC 6724 section 5 rule 8.
@@ -1662,6 +1765,17 @@ func (e *endpoint) leaveGroupLocked(addr tcpip.Address) *tcpip.Error {
 	return e.mu.mld.leaveGroup(addr)
 }
 
+// ChangeGroupSourceFilter implements stack.GroupAddressableEndpoint.
+func (e *endpoint) ChangeGroupSourceFilter(addr tcpip.Address, old, new *tcpip.MulticastSourceFilter) *tcpip.Error {
+	if !header.IsV6MulticastAddress(addr) {
+		return tcpip.ErrBadAddress
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.mu.mld.changeGroupSourceFilter(addr, old, new)
+}
+
 // IsInGroup implements stack.GroupAddressableEndpoint.
 func (e *endpoint) IsInGroup(addr tcpip.Address) bool {
 	e.mu.RLock()
@@ -1675,6 +1789,8 @@ func (e *endpoint) Stats() stack.NetworkEndpointStats {
 }
 
 var _ stack.ForwardingNetworkProtocol = (*protocol)(nil)
+var _ stack.AddressLabelingNetworkProtocol = (*protocol)(nil)
+var _ stack.MulticastForwardingNetworkProtocol = (*protocol)(nil)
 var _ stack.NetworkProtocol = (*protocol)(nil)
 var _ fragmentation.TimeoutHandler = (*protocol)(nil)
 
@@ -1706,6 +1822,17 @@ type protocol struct {
 	forwarding uint32
 
 	fragmentation *fragmentation.Fragmentation
+
+	addressLabels struct {
+		sync.RWMutex
+
+		// labels is the policy table used for source address selection, sorted
+		// by sortAddressLabels.
+		labels []stack.AddressLabel
+	}
+
+	// multicastRouteTable holds the routes used to forward multicast packets.
+	multicastRouteTable ip.MulticastRouteTable
 }
 
 // Number returns the ipv6 protocol number.
@@ -1793,6 +1920,40 @@ func (p *protocol) DefaultTTL() uint8 {
 	return uint8(atomic.LoadUint32(&p.defaultTTL))
 }
 
+// AddressLabels implements stack.AddressLabelingNetworkProtocol.
+func (p *protocol) AddressLabels() []stack.AddressLabel {
+	p.addressLabels.RLock()
+	defer p.addressLabels.RUnlock()
+	return append([]stack.AddressLabel(nil), p.addressLabels.labels...)
+}
+
+// SetAddressLabels implements stack.AddressLabelingNetworkProtocol.
+func (p *protocol) SetAddressLabels(labels []stack.AddressLabel) *tcpip.Error {
+	type key struct {
+		prefix tcpip.Subnet
+		nic    tcpip.NICID
+	}
+	seen := make(map[key]struct{}, len(labels))
+	for _, l := range labels {
+		if len(l.Prefix.ID()) != header.IPv6AddressSize {
+			return tcpip.ErrBadAddress
+		}
+		k := key{prefix: l.Prefix, nic: l.NIC}
+		if _, ok := seen[k]; ok {
+			return tcpip.ErrDuplicateAddress
+		}
+		seen[k] = struct{}{}
+	}
+
+	labels = append([]stack.AddressLabel(nil), labels...)
+	sortAddressLabels(labels)
+
+	p.addressLabels.Lock()
+	defer p.addressLabels.Unlock()
+	p.addressLabels.labels = labels
+	return nil
+}
+
 // Close implements stack.TransportProtocol.Close.
 func (*protocol) Close() {}
 
@@ -1838,6 +1999,55 @@ func (p *protocol) SetForwarding(v bool) {
 	}
 }
 
+// EnableMulticastForwarding implements
+// stack.MulticastForwardingNetworkProtocol.
+func (p *protocol) EnableMulticastForwarding(disp stack.MulticastForwardingEventDispatcher) bool {
+	return p.multicastRouteTable.Enable(disp)
+}
+
+// DisableMulticastForwarding implements
+// stack.MulticastForwardingNetworkProtocol.
+func (p *protocol) DisableMulticastForwarding() {
+	p.multicastRouteTable.Disable()
+}
+
+// AddMulticastRoute implements stack.MulticastForwardingNetworkProtocol.
+func (p *protocol) AddMulticastRoute(addresses stack.UnicastSourceAndMulticastDestination, route stack.MulticastRoute) *tcpip.Error {
+	if err := validateMulticastRouteAddresses(addresses); err != nil {
+		return err
+	}
+
+	pkts, err := p.multicastRouteTable.AddRoute(addresses, route)
+	if err != nil {
+		return err
+	}
+	for _, pkt := range pkts {
+		p.forwardValidatedMulticastPacket(pkt, route)
+	}
+	return nil
+}
+
+// RemoveMulticastRoute implements stack.MulticastForwardingNetworkProtocol.
+func (p *protocol) RemoveMulticastRoute(addresses stack.UnicastSourceAndMulticastDestination) *tcpip.Error {
+	if err := validateMulticastRouteAddresses(addresses); err != nil {
+		return err
+	}
+	return p.multicastRouteTable.RemoveRoute(addresses)
+}
+
+// validateMulticastRouteAddresses returns an error if addresses are not a
+// unicast IPv6 source address and a forwardable multicast IPv6 destination
+// address.
+func validateMulticastRouteAddresses(addresses stack.UnicastSourceAndMulticastDestination) *tcpip.Error {
+	if len(addresses.Source) != header.IPv6AddressSize || header.IsV6MulticastAddress(addresses.Source) || header.IsV6LinkLocalAddress(addresses.Source) {
+		return tcpip.ErrBadAddress
+	}
+	if !header.IsV6MulticastAddress(addresses.Destination) || header.V6MulticastScope(addresses.Destination) <= header.IPv6LinkLocalMulticastScope {
+		return tcpip.ErrBadAddress
+	}
+	return nil
+}
+
 // calculateNetworkMTU calculates the network-layer payload MTU based on the
 // link-layer payload MTU and the length of every IPv6 header.
 // Note that this is different than the Payload Length field of the IPv6 header,
@@ -1926,6 +2136,8 @@ func NewProtocolWithOptions(opts Options) stack.NetworkProtocolFactory {
 		}
 		p.fragmentation = fragmentation.NewFragmentation(header.IPv6FragmentExtHdrFragmentOffsetBytesPerUnit, fragmentation.HighFragThreshold, fragmentation.LowFragThreshold, ReassembleTimeout, s.Clock(), p)
 		p.mu.eps = make(map[tcpip.NICID]*endpoint)
+		p.addressLabels.labels = defaultAddressLabels()
+		p.multicastRouteTable.Init(s.Clock())
 		p.SetDefaultTTL(DefaultTTL)
 		return p
 	}
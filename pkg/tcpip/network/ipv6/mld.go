@@ -31,6 +31,16 @@ const (
 	//
 	// Obtained from RFC 2710 Section 7.10.
 	UnsolicitedReportIntervalMax = 10 * time.Second
+
+	// olderVersionQuerierPresentTimeout is the time an interface waits after
+	// hearing an MLDv1 query before it stops performing MLDv1 in place of
+	// MLDv2.
+	//
+	// As per RFC 3810 section 9.12, the Older Version Querier Present Timeout
+	// is the Robustness Variable times the Query Interval plus one Query
+	// Response Interval. The default values of these are 2, 125 seconds and 10
+	// seconds respectively.
+	olderVersionQuerierPresentTimeout = 260 * time.Second
 )
 
 // MLDOptions holds options for MLD.
@@ -44,9 +54,18 @@ type MLDOptions struct {
 	// This field is ignored and is always assumed to be false for interfaces
 	// without neighbouring nodes (e.g. loopback).
 	Enabled bool
+
+	// V2 indicates whether MLDv2, as defined by RFC 3810, will be performed in
+	// place of MLDv1 when MLD is enabled.
+	//
+	// MLDv2 supports source filters, as used by source-specific multicast.
+	// MLDv1 is still performed while MLDv1 queriers are present on the
+	// network.
+	V2 bool
 }
 
 var _ ip.MulticastGroupProtocol = (*mldState)(nil)
+var _ ip.MulticastGroupProtocolV2 = (*mldState)(nil)
 
 // mldState is the per-interface MLD state.
 //
@@ -56,6 +75,12 @@ type mldState struct {
 	ep *endpoint
 
 	genericMulticastProtocol ip.GenericMulticastProtocolState
+
+	// v1QuerierPresentJob is scheduled when this interface receives an MLDv1
+	// query while MLDv2 is enabled. MLDv1 is performed until it fires.
+	//
+	// Must not be nil once mldState is initialized.
+	v1QuerierPresentJob *tcpip.Job
 }
 
 // Enabled implements ip.MulticastGroupProtocol.
@@ -80,18 +105,50 @@ func (mld *mldState) SendLeave(groupAddress tcpip.Address) *tcpip.Error {
 	return err
 }
 
+// SendReportV2 implements ip.MulticastGroupProtocolV2.
+//
+// Precondition: mld.ep.mu must be read locked.
+func (mld *mldState) SendReportV2(records []ip.MulticastGroupRecord) (bool, *tcpip.Error) {
+	// As per RFC 3810 section 5.2.14,
+	//
+	//   Version 2 Multicast Listener Reports are sent with an IP destination
+	//   address of FF02:0:0:0:0:0:0:16, to which all MLDv2-capable multicast
+	//   routers listen.
+	s := header.MLDv2ReportSerializer{
+		Records: make([]header.MLDv2ReportMulticastAddressRecord, 0, len(records)),
+	}
+	for _, r := range records {
+		s.Records = append(s.Records, header.MLDv2ReportMulticastAddressRecord{
+			RecordType:       header.MLDv2ReportRecordType(r.Type),
+			MulticastAddress: r.GroupAddress,
+			Sources:          r.Sources,
+		})
+	}
+	icmp := header.ICMPv6(buffer.NewView(header.ICMPv6HeaderSize + s.Length()))
+	icmp.SetType(header.ICMPv6MulticastListenerV2Report)
+	s.SerializeInto(icmp.MessageBody())
+	return mld.writeICMPPacket(header.MLDv2RoutersAddress, icmp)
+}
+
 // init sets up an mldState struct, and is required to be called before using
 // a new mldState.
 //
 // Must only be called once for the lifetime of mld.
 func (mld *mldState) init(ep *endpoint) {
 	mld.ep = ep
-	mld.genericMulticastProtocol.Init(&ep.mu.RWMutex, ip.GenericMulticastProtocolOptions{
+	opts := ip.GenericMulticastProtocolOptions{
 		Rand:                      ep.protocol.stack.Rand(),
 		Clock:                     ep.protocol.stack.Clock(),
 		Protocol:                  mld,
 		MaxUnsolicitedReportDelay: UnsolicitedReportIntervalMax,
 		AllNodesAddress:           header.IPv6AllNodesMulticastAddress,
+	}
+	if ep.protocol.options.MLD.V2 {
+		opts.ProtocolV2 = mld
+	}
+	mld.genericMulticastProtocol.Init(&ep.mu.RWMutex, opts)
+	mld.v1QuerierPresentJob = ep.protocol.stack.NewJob(&ep.mu, func() {
+		mld.genericMulticastProtocol.SetV1CompatibilityLocked(false)
 	})
 }
 
@@ -99,9 +156,29 @@ func (mld *mldState) init(ep *endpoint) {
 //
 // Precondition: mld.ep.mu must be locked.
 func (mld *mldState) handleMulticastListenerQuery(mldHdr header.MLD) {
+	// As per RFC 3810 section 8.2.1, nodes performing MLDv2 switch to MLDv1
+	// while MLDv1 queriers are present.
+	if mld.ep.protocol.options.MLD.V2 && mld.Enabled() {
+		mld.v1QuerierPresentJob.Cancel()
+		mld.v1QuerierPresentJob.Schedule(olderVersionQuerierPresentTimeout)
+		mld.genericMulticastProtocol.SetV1CompatibilityLocked(true)
+	}
+
 	mld.genericMulticastProtocol.HandleQueryLocked(mldHdr.MulticastAddress(), mldHdr.MaximumResponseDelay())
 }
 
+// handleMulticastListenerQueryV2 handles an MLDv2 query message.
+//
+// Precondition: mld.ep.mu must be locked.
+func (mld *mldState) handleMulticastListenerQueryV2(mldHdr header.MLDv2Query) {
+	sources, ok := mldHdr.Sources()
+	if !ok {
+		mld.ep.stats.icmp.packetsReceived.invalid.Increment()
+		return
+	}
+	mld.genericMulticastProtocol.HandleQueryV2Locked(mldHdr.MulticastAddress(), mldHdr.MaximumResponseDelay(), sources, mldHdr.QuerierRobustnessVariable())
+}
+
 // handleMulticastListenerReport handles a report message.
 //
 // Precondition: mld.ep.mu must be locked.
@@ -119,6 +196,20 @@ func (mld *mldState) joinGroup(groupAddress tcpip.Address) {
 	mld.genericMulticastProtocol.JoinGroupLocked(groupAddress)
 }
 
+// changeGroupSourceFilter replaces the source filter of one of the joins of
+// the group, sending and scheduling the required messages.
+//
+// Precondition: mld.ep.mu must be locked.
+func (mld *mldState) changeGroupSourceFilter(groupAddress tcpip.Address, old, new *tcpip.MulticastSourceFilter) *tcpip.Error {
+	// ChangeGroupSourceFilterLocked returns false only if the group was not
+	// joined.
+	if mld.genericMulticastProtocol.ChangeGroupSourceFilterLocked(groupAddress, old, new) {
+		return nil
+	}
+
+	return tcpip.ErrBadLocalAddress
+}
+
 // isInGroup returns true if the specified group has been joined locally.
 //
 // Precondition: mld.ep.mu must be read locked.
@@ -167,20 +258,29 @@ func (mld *mldState) sendQueuedReports() {
 //
 // Precondition: mld.ep.mu must be read locked.
 func (mld *mldState) writePacket(destAddress, groupAddress tcpip.Address, mldType header.ICMPv6Type) (bool, *tcpip.Error) {
+	icmp := header.ICMPv6(buffer.NewView(header.ICMPv6HeaderSize + header.MLDMinimumSize))
+	icmp.SetType(mldType)
+	header.MLD(icmp.MessageBody()).SetMulticastAddress(groupAddress)
+	return mld.writeICMPPacket(destAddress, icmp)
+}
+
+// writeICMPPacket sends an MLD message.
+//
+// Precondition: mld.ep.mu must be read locked.
+func (mld *mldState) writeICMPPacket(destAddress tcpip.Address, icmp header.ICMPv6) (bool, *tcpip.Error) {
 	sentStats := mld.ep.stats.icmp.packetsSent
 	var mldStat tcpip.MultiCounterStat
-	switch mldType {
+	switch mldType := icmp.Type(); mldType {
 	case header.ICMPv6MulticastListenerReport:
 		mldStat = sentStats.multicastListenerReport
 	case header.ICMPv6MulticastListenerDone:
 		mldStat = sentStats.multicastListenerDone
+	case header.ICMPv6MulticastListenerV2Report:
+		mldStat = sentStats.multicastListenerReportV2
 	default:
 		panic(fmt.Sprintf("unrecognized mld type = %d", mldType))
 	}
 
-	icmp := header.ICMPv6(buffer.NewView(header.ICMPv6HeaderSize + header.MLDMinimumSize))
-	icmp.SetType(mldType)
-	header.MLD(icmp.MessageBody()).SetMulticastAddress(groupAddress)
 	// As per RFC 2710 section 3,
 	//
 	//   All MLD messages described in this document are sent with a link-local
@@ -199,6 +199,9 @@ var (
 
 // NDPEndpoint is an endpoint that supports NDP.
 type NDPEndpoint interface {
+	// NDPConfigurations returns the NDP configurations.
+	NDPConfigurations() NDPConfigurations
+
 	// SetNDPConfigurations sets the NDP configurations.
 	SetNDPConfigurations(NDPConfigurations)
 }
@@ -321,6 +324,19 @@ type NDPDispatcher interface {
 	// be increased, decreased or completely invalidated when lifetime = 0.
 	OnDNSSearchListOption(nicID tcpip.NICID, domainNames []string, lifetime time.Duration)
 
+	// OnPref64Option is called when the stack learns of the NAT64 prefix of
+	// the network through NDP, as per RFC 8781.
+	//
+	// It is up to the caller to use the prefix for only its valid lifetime.
+	// OnPref64Option may be called with a new or already known prefix. If
+	// called with a known prefix, its valid lifetime must be refreshed to
+	// lifetime (it may be increased, decreased or completely invalidated
+	// when lifetime = 0).
+	//
+	// This function is not permitted to block indefinitely. It must not
+	// call functions on the stack itself.
+	OnPref64Option(nicID tcpip.NICID, prefix tcpip.Subnet, lifetime time.Duration)
+
 	// OnDHCPv6Configuration is called with an updated configuration that is
 	// available via DHCPv6 for the passed NIC.
 	//
@@ -408,6 +424,11 @@ type NDPConfigurations struct {
 	// RegenAdvanceDuration is the duration before the deprecation of a temporary
 	// address when a new address will be generated.
 	RegenAdvanceDuration time.Duration
+
+	// PreferPublicAddresses reverses the preference for temporary addresses
+	// over public addresses during source address selection, as allowed by
+	// RFC 6724 section 5 rule 7.
+	PreferPublicAddresses bool
 }
 
 // DefaultNDPConfigurations returns an NDPConfigurations populated with
@@ -862,6 +883,19 @@ func (ndp *ndpState) handleRA(ip tcpip.Address, ra header.NDPRouterAdvert) {
 			domainNames, _ := opt.DomainNames()
 			ndp.ep.protocol.options.NDPDisp.OnDNSSearchListOption(ndp.ep.nic.ID(), domainNames, opt.Lifetime())
 
+		case header.NDPPref64:
+			if ndp.ep.protocol.options.NDPDisp == nil {
+				continue
+			}
+
+			// Options with an invalid Prefix Length Code must be ignored, as
+			// per RFC 8781 section 4.
+			prefix, err := opt.Subnet()
+			if err != nil {
+				continue
+			}
+			ndp.ep.protocol.options.NDPDisp.OnPref64Option(ndp.ep.nic.ID(), prefix, opt.Lifetime())
+
 		case header.NDPPrefixInformation:
 			prefix := opt.Subnet()
 
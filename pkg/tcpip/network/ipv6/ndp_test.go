@@ -125,6 +125,9 @@ func (*testNDPDispatcher) OnRecursiveDNSServerOption(tcpip.NICID, []tcpip.Addres
 func (*testNDPDispatcher) OnDNSSearchListOption(tcpip.NICID, []string, time.Duration) {
 }
 
+func (*testNDPDispatcher) OnPref64Option(tcpip.NICID, tcpip.Subnet, time.Duration) {
+}
+
 func (*testNDPDispatcher) OnDHCPv6Configuration(tcpip.NICID, DHCPv6ConfigurationFromNDPRA) {
 }
 
@@ -48,20 +48,21 @@ type sharedStats struct {
 // LINT.IfChange(multiCounterICMPv6PacketStats)
 
 type multiCounterICMPv6PacketStats struct {
-	echoRequest             tcpip.MultiCounterStat
-	echoReply               tcpip.MultiCounterStat
-	dstUnreachable          tcpip.MultiCounterStat
-	packetTooBig            tcpip.MultiCounterStat
-	timeExceeded            tcpip.MultiCounterStat
-	paramProblem            tcpip.MultiCounterStat
-	routerSolicit           tcpip.MultiCounterStat
-	routerAdvert            tcpip.MultiCounterStat
-	neighborSolicit         tcpip.MultiCounterStat
-	neighborAdvert          tcpip.MultiCounterStat
-	redirectMsg             tcpip.MultiCounterStat
-	multicastListenerQuery  tcpip.MultiCounterStat
-	multicastListenerReport tcpip.MultiCounterStat
-	multicastListenerDone   tcpip.MultiCounterStat
+	echoRequest               tcpip.MultiCounterStat
+	echoReply                 tcpip.MultiCounterStat
+	dstUnreachable            tcpip.MultiCounterStat
+	packetTooBig              tcpip.MultiCounterStat
+	timeExceeded              tcpip.MultiCounterStat
+	paramProblem              tcpip.MultiCounterStat
+	routerSolicit             tcpip.MultiCounterStat
+	routerAdvert              tcpip.MultiCounterStat
+	neighborSolicit           tcpip.MultiCounterStat
+	neighborAdvert            tcpip.MultiCounterStat
+	redirectMsg               tcpip.MultiCounterStat
+	multicastListenerQuery    tcpip.MultiCounterStat
+	multicastListenerReport   tcpip.MultiCounterStat
+	multicastListenerDone     tcpip.MultiCounterStat
+	multicastListenerReportV2 tcpip.MultiCounterStat
 }
 
 func (m *multiCounterICMPv6PacketStats) init(a, b *tcpip.ICMPv6PacketStats) {
@@ -79,6 +80,7 @@ func (m *multiCounterICMPv6PacketStats) init(a, b *tcpip.ICMPv6PacketStats) {
 	m.multicastListenerQuery.Init(a.MulticastListenerQuery, b.MulticastListenerQuery)
 	m.multicastListenerReport.Init(a.MulticastListenerReport, b.MulticastListenerReport)
 	m.multicastListenerDone.Init(a.MulticastListenerDone, b.MulticastListenerDone)
+	m.multicastListenerReportV2.Init(a.MulticastListenerReportV2, b.MulticastListenerReportV2)
 }
 
 // LINT.ThenChange(../../tcpip.go:ICMPv6PacketStats)
@@ -133,6 +133,22 @@ type SocketOptions struct {
 	// provided with incoming packets such as interface index and address.
 	receivePacketInfoEnabled uint32
 
+	// receiveIPv6PacketInfoEnabled is used to specify if the IPV6_PKTINFO
+	// ancillary message is passed with incoming packets.
+	receiveIPv6PacketInfoEnabled uint32
+
+	// receiveTTLEnabled is used to specify if the IP_TTL ancillary message is
+	// passed with incoming packets.
+	receiveTTLEnabled uint32
+
+	// receiveHopLimitEnabled is used to specify if the IPV6_HOPLIMIT
+	// ancillary message is passed with incoming packets.
+	receiveHopLimitEnabled uint32
+
+	// receiveFlowInfoEnabled is used to specify if the IPV6_FLOWINFO
+	// ancillary message is passed with incoming packets.
+	receiveFlowInfoEnabled uint32
+
 	// hdrIncludeEnabled is used to indicate for a raw endpoint that all packets
 	// being written have an IP header and the endpoint should not attach an IP
 	// header.
@@ -163,6 +179,15 @@ type SocketOptions struct {
 	// is enabled.
 	recvErrEnabled uint32
 
+	// transparent is the value of IP(V6)_TRANSPARENT: whether the endpoint
+	// may use addresses not assigned to the stack, as transparent proxies do.
+	transparent uint32
+
+	// zeroCopyEnabled is the value of SO_ZEROCOPY: whether MSG_ZEROCOPY sends
+	// report their completion on the error queue. Their payload is copied
+	// all the same.
+	zeroCopyEnabled uint32
+
 	// errQueue is the per-socket error queue. It is protected by errQueueMu.
 	errQueueMu sync.Mutex `state:"nosave"`
 	errQueue   sockErrorList
@@ -170,6 +195,18 @@ type SocketOptions struct {
 	// bindToDevice determines the device to which the socket is bound.
 	bindToDevice int32
 
+	// busyPollUsec is the value of SO_BUSY_POLL: the approximate time in
+	// microseconds to busy poll on a blocking receive before sleeping.
+	busyPollUsec uint32
+
+	// mark is the value of SO_MARK: the mark of the packets sent by the
+	// socket, matched by the routing rules.
+	mark uint32
+
+	// incomingCPU is the value of SO_INCOMING_CPU: the CPU that processes
+	// the packets of the socket.
+	incomingCPU int32
+
 	// mu protects the access to the below fields.
 	mu sync.Mutex `state:"nosave"`
 
@@ -300,6 +337,46 @@ func (so *SocketOptions) SetReceivePacketInfo(v bool) {
 	storeAtomicBool(&so.receivePacketInfoEnabled, v)
 }
 
+// GetReceiveIPv6PacketInfo gets value for IPV6_RECVPKTINFO option.
+func (so *SocketOptions) GetReceiveIPv6PacketInfo() bool {
+	return atomic.LoadUint32(&so.receiveIPv6PacketInfoEnabled) != 0
+}
+
+// SetReceiveIPv6PacketInfo sets value for IPV6_RECVPKTINFO option.
+func (so *SocketOptions) SetReceiveIPv6PacketInfo(v bool) {
+	storeAtomicBool(&so.receiveIPv6PacketInfoEnabled, v)
+}
+
+// GetReceiveTTL gets value for IP_RECVTTL option.
+func (so *SocketOptions) GetReceiveTTL() bool {
+	return atomic.LoadUint32(&so.receiveTTLEnabled) != 0
+}
+
+// SetReceiveTTL sets value for IP_RECVTTL option.
+func (so *SocketOptions) SetReceiveTTL(v bool) {
+	storeAtomicBool(&so.receiveTTLEnabled, v)
+}
+
+// GetReceiveHopLimit gets value for IPV6_RECVHOPLIMIT option.
+func (so *SocketOptions) GetReceiveHopLimit() bool {
+	return atomic.LoadUint32(&so.receiveHopLimitEnabled) != 0
+}
+
+// SetReceiveHopLimit sets value for IPV6_RECVHOPLIMIT option.
+func (so *SocketOptions) SetReceiveHopLimit(v bool) {
+	storeAtomicBool(&so.receiveHopLimitEnabled, v)
+}
+
+// GetReceiveFlowInfo gets value for IPV6_FLOWINFO option.
+func (so *SocketOptions) GetReceiveFlowInfo() bool {
+	return atomic.LoadUint32(&so.receiveFlowInfoEnabled) != 0
+}
+
+// SetReceiveFlowInfo sets value for IPV6_FLOWINFO option.
+func (so *SocketOptions) SetReceiveFlowInfo(v bool) {
+	storeAtomicBool(&so.receiveFlowInfoEnabled, v)
+}
+
 // GetHeaderIncluded gets value for IP_HDRINCL option.
 func (so *SocketOptions) GetHeaderIncluded() bool {
 	return atomic.LoadUint32(&so.hdrIncludedEnabled) != 0
@@ -421,6 +498,14 @@ const (
 
 	// SockExtErrorOriginICMP6 indicates an IPv6 ICMP error.
 	SockExtErrorOriginICMP6
+
+	// SockExtErrorOriginTimestamping indicates a transmit timestamp rather
+	// than an error.
+	SockExtErrorOriginTimestamping
+
+	// SockExtErrorOriginZeroCopy indicates the completion of MSG_ZEROCOPY
+	// sends rather than an error.
+	SockExtErrorOriginZeroCopy
 )
 
 // IsICMPErr indicates if the error originated from an ICMP error.
@@ -444,6 +529,14 @@ type SockError struct {
 	ErrCode uint8
 	// ErrInfo is additional info about the error.
 	ErrInfo uint32
+	// ErrData is other data about the error. For timestamps, it holds the
+	// SOF_TIMESTAMPING_OPT_ID key of the timestamped data. For zerocopy
+	// completions, ErrInfo and ErrData hold the first and last completed
+	// send.
+	ErrData uint32
+	// Timestamp is the time (in ns) recorded by a
+	// SockExtErrorOriginTimestamping entry.
+	Timestamp int64
 
 	// Payload is the errant packet's payload.
 	Payload []byte
@@ -492,6 +585,26 @@ func (so *SocketOptions) QueueErr(err *SockError) {
 	so.errQueue.PushBack(err)
 }
 
+// QueueZeroCopyCompletion queues a notification that the MSG_ZEROCOPY sends
+// numbered lo to hi, inclusive, completed with the given code. As in Linux,
+// the notification is merged into the one at the back of the error queue if
+// it extends its range.
+func (so *SocketOptions) QueueZeroCopyCompletion(lo, hi uint32, code uint8, net NetworkProtocolNumber) {
+	so.errQueueMu.Lock()
+	defer so.errQueueMu.Unlock()
+	if tail := so.errQueue.Back(); tail != nil && tail.ErrOrigin == SockExtErrorOriginZeroCopy && tail.ErrCode == code && tail.ErrData+1 == lo {
+		tail.ErrData = hi
+		return
+	}
+	so.errQueue.PushBack(&SockError{
+		ErrOrigin: SockExtErrorOriginZeroCopy,
+		ErrCode:   code,
+		ErrInfo:   lo,
+		ErrData:   hi,
+		NetProto:  net,
+	})
+}
+
 // QueueLocalErr queues a local error onto the local queue.
 func (so *SocketOptions) QueueLocalErr(err *Error, net NetworkProtocolNumber, info uint32, dst FullAddress, payload []byte) {
 	so.QueueErr(&SockError{
@@ -504,6 +617,56 @@ func (so *SocketOptions) QueueLocalErr(err *Error, net NetworkProtocolNumber, in
 	})
 }
 
+// GetBusyPoll gets value for SO_BUSY_POLL option, in microseconds.
+func (so *SocketOptions) GetBusyPoll() uint32 {
+	return atomic.LoadUint32(&so.busyPollUsec)
+}
+
+// SetBusyPoll sets value for SO_BUSY_POLL option, in microseconds.
+func (so *SocketOptions) SetBusyPoll(usec uint32) {
+	atomic.StoreUint32(&so.busyPollUsec, usec)
+}
+
+// GetTransparent gets value for IP(V6)_TRANSPARENT option.
+func (so *SocketOptions) GetTransparent() bool {
+	return atomic.LoadUint32(&so.transparent) != 0
+}
+
+// SetTransparent sets value for IP(V6)_TRANSPARENT option.
+func (so *SocketOptions) SetTransparent(v bool) {
+	storeAtomicBool(&so.transparent, v)
+}
+
+// GetZeroCopy gets value for SO_ZEROCOPY option.
+func (so *SocketOptions) GetZeroCopy() bool {
+	return atomic.LoadUint32(&so.zeroCopyEnabled) != 0
+}
+
+// SetZeroCopy sets value for SO_ZEROCOPY option.
+func (so *SocketOptions) SetZeroCopy(v bool) {
+	storeAtomicBool(&so.zeroCopyEnabled, v)
+}
+
+// GetMark gets value for SO_MARK option.
+func (so *SocketOptions) GetMark() uint32 {
+	return atomic.LoadUint32(&so.mark)
+}
+
+// SetMark sets value for SO_MARK option.
+func (so *SocketOptions) SetMark(mark uint32) {
+	atomic.StoreUint32(&so.mark, mark)
+}
+
+// GetIncomingCPU gets value for SO_INCOMING_CPU option.
+func (so *SocketOptions) GetIncomingCPU() int32 {
+	return atomic.LoadInt32(&so.incomingCPU)
+}
+
+// SetIncomingCPU sets value for SO_INCOMING_CPU option.
+func (so *SocketOptions) SetIncomingCPU(cpu int32) {
+	atomic.StoreInt32(&so.incomingCPU, cpu)
+}
+
 // GetBindToDevice gets value for SO_BINDTODEVICE option.
 func (so *SocketOptions) GetBindToDevice() int32 {
 	return atomic.LoadInt32(&so.bindToDevice)
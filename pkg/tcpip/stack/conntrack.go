@@ -17,6 +17,7 @@ package stack
 import (
 	"encoding/binary"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"gvisor.dev/gvisor/pkg/tcpip"
@@ -33,9 +34,119 @@ import (
 //
 // Currently, only TCP tracking is supported.
 
-// Our hash table has 16K buckets.
-// TODO(gvisor.dev/issue/170): These should be tunable.
-const numBuckets = 1 << 14
+// Default parameters of connection tracking, from Linux.
+const (
+	// defaultBuckets is the default number of buckets of the hash table.
+	defaultBuckets = 1 << 14
+
+	// defaultMaxConnections is the default maximum number of tracked
+	// connections.
+	defaultMaxConnections = 1 << 16
+
+	// Linux doesn't delete established connections for 5(!) days, and lets
+	// connections in most other states remain for <= 120 seconds.
+	defaultTCPSynSentTimeout     = 120 * time.Second
+	defaultTCPEstablishedTimeout = 5 * 24 * time.Hour
+	defaultTCPTimeWaitTimeout    = 120 * time.Second
+	defaultTCPCloseTimeout       = 10 * time.Second
+)
+
+// ConnTrackSettings holds the tunable parameters of connection tracking.
+//
+// +stateify savable
+type ConnTrackSettings struct {
+	// Buckets is the number of buckets of the hash table of connections.
+	Buckets int
+
+	// MaxConnections is the maximum number of tracked connections, or 0 if
+	// the number of connections is not limited. Packets creating new
+	// connections are dropped when the table is full.
+	MaxConnections int
+
+	// TCPSynSentTimeout is how long connections that are not established
+	// yet are kept without seeing a packet.
+	TCPSynSentTimeout time.Duration
+
+	// TCPEstablishedTimeout is how long established connections are kept
+	// without seeing a packet.
+	TCPEstablishedTimeout time.Duration
+
+	// TCPTimeWaitTimeout is how long gracefully closed connections are kept.
+	TCPTimeWaitTimeout time.Duration
+
+	// TCPCloseTimeout is how long reset connections are kept.
+	TCPCloseTimeout time.Duration
+}
+
+// DefaultConnTrackSettings returns the default connection tracking settings.
+func DefaultConnTrackSettings() ConnTrackSettings {
+	return ConnTrackSettings{
+		Buckets:               defaultBuckets,
+		MaxConnections:        defaultMaxConnections,
+		TCPSynSentTimeout:     defaultTCPSynSentTimeout,
+		TCPEstablishedTimeout: defaultTCPEstablishedTimeout,
+		TCPTimeWaitTimeout:    defaultTCPTimeWaitTimeout,
+		TCPCloseTimeout:       defaultTCPCloseTimeout,
+	}
+}
+
+// ConnTrackTuple identifies a tracked connection in one direction.
+type ConnTrackTuple struct {
+	SrcAddr tcpip.Address
+	SrcPort uint16
+	DstAddr tcpip.Address
+	DstPort uint16
+}
+
+// ConnTrackEntry is a snapshot of a tracked connection.
+type ConnTrackEntry struct {
+	// ID uniquely identifies the connection in the table.
+	ID uint32
+
+	// NetProto is the network protocol of the connection.
+	NetProto tcpip.NetworkProtocolNumber
+
+	// TransProto is the transport protocol of the connection.
+	TransProto tcpip.TransportProtocolNumber
+
+	// Original is the tuple of packets in the original direction.
+	Original ConnTrackTuple
+
+	// Reply is the tuple expected for packets in the reply direction.
+	Reply ConnTrackTuple
+
+	// TCPState is the state of the TCP connection.
+	TCPState tcpconntrack.Result
+
+	// SeenReply is true if a packet was seen in the reply direction.
+	SeenReply bool
+
+	// DstNAT is true if the destination of the connection is translated.
+	DstNAT bool
+
+	// Timeout is the time left before the connection is deleted, unless a
+	// packet is seen.
+	Timeout time.Duration
+}
+
+// ConnTrackStats holds the statistics of connection tracking.
+type ConnTrackStats struct {
+	// Connections is the number of tracked connections.
+	Connections int
+
+	// Found is the number of packets matching a tracked connection.
+	Found uint64
+
+	// Insert is the number of connections inserted in the table.
+	Insert uint64
+
+	// InsertFailed is the number of connections that could not be inserted
+	// because the same connection was inserted concurrently.
+	InsertFailed uint64
+
+	// Drop is the number of packets dropped because the table was full.
+	Drop uint64
+}
 
 // Direction of the tuple.
 type direction int
@@ -122,22 +233,37 @@ type conn struct {
 	// lastUsed is the last time the connection saw a relevant packet, and
 	// is updated by each packet on the connection. It is protected by mu.
 	lastUsed time.Time `state:".(unixTime)"`
+	// seenReply is true if a packet was seen in the reply direction. It is
+	// protected by mu.
+	seenReply bool
+
+	// id uniquely identifies the connection in the table. It is immutable
+	// once the connection is inserted.
+	id uint32
+}
+
+// timeoutLocked returns how long the connection is kept without seeing a
+// packet, based on its state.
+//
+// Precondition: cn.mu must be held.
+func (cn *conn) timeoutLocked(settings *ConnTrackSettings) time.Duration {
+	switch cn.tcb.State() {
+	case tcpconntrack.ResultAlive:
+		return settings.TCPEstablishedTimeout
+	case tcpconntrack.ResultClosedByPeer, tcpconntrack.ResultClosedBySelf:
+		return settings.TCPTimeWaitTimeout
+	case tcpconntrack.ResultReset:
+		return settings.TCPCloseTimeout
+	default:
+		return settings.TCPSynSentTimeout
+	}
 }
 
 // timedOut returns whether the connection timed out based on its state.
-func (cn *conn) timedOut(now time.Time) bool {
-	const establishedTimeout = 5 * 24 * time.Hour
-	const defaultTimeout = 120 * time.Second
+func (cn *conn) timedOut(now time.Time, settings *ConnTrackSettings) bool {
 	cn.mu.Lock()
 	defer cn.mu.Unlock()
-	if cn.tcb.State() == tcpconntrack.ResultAlive {
-		// Use the same default as Linux, which doesn't delete
-		// established connections for 5(!) days.
-		return now.Sub(cn.lastUsed) > establishedTimeout
-	}
-	// Use the same default as Linux, which lets connections in most states
-	// other than established remain for <= 120 seconds.
-	return now.Sub(cn.lastUsed) > defaultTimeout
+	return now.Sub(cn.lastUsed) > cn.timeoutLocked(settings)
 }
 
 // update the connection tracking state.
@@ -179,12 +305,30 @@ type ConnTrack struct {
 	// It is immutable.
 	seed uint32
 
-	// mu protects the buckets slice, but not buckets' contents. Only take
-	// the write lock if you are modifying the slice or saving for S/R.
+	// mu protects the buckets slice and settings, but not buckets'
+	// contents. Only take the write lock if you are modifying them or
+	// saving for S/R.
 	mu sync.RWMutex `state:"nosave"`
 
 	// buckets is protected by mu.
 	buckets []bucket
+
+	// settings is protected by mu.
+	settings ConnTrackSettings
+
+	// connections is the number of connections in buckets. It is accessed
+	// atomically.
+	connections int64
+
+	// lastID is the last ID given to a connection. It is accessed
+	// atomically.
+	lastID uint32
+
+	// The following statistics are accessed atomically.
+	found        uint64
+	insert       uint64
+	insertFailed uint64
+	drop         uint64
 }
 
 // +stateify savable
@@ -245,23 +389,26 @@ func (ct *ConnTrack) connFor(pkt *PacketBuffer) (*conn, direction) {
 }
 
 func (ct *ConnTrack) connForTID(tid tupleID) (*conn, direction) {
-	bucket := ct.bucket(tid)
 	now := time.Now()
 
 	ct.mu.RLock()
 	defer ct.mu.RUnlock()
+	bucket := ct.bucketLocked(tid)
 	ct.buckets[bucket].mu.Lock()
 	defer ct.buckets[bucket].mu.Unlock()
 
 	// Iterate over the tuples in a bucket, cleaning up any unused
 	// connections we find.
-	for other := ct.buckets[bucket].tuples.Front(); other != nil; other = other.Next() {
+	var next *tuple
+	for other := ct.buckets[bucket].tuples.Front(); other != nil; other = next {
+		next = other.Next()
 		// Clean up any timed-out connections we happen to find.
 		if ct.reapTupleLocked(other, bucket, now) {
 			// The tuple expired.
 			continue
 		}
 		if tid == other.tupleID {
+			atomic.AddUint64(&ct.found, 1)
 			return other.conn, other.direction
 		}
 	}
@@ -269,13 +416,16 @@ func (ct *ConnTrack) connForTID(tid tupleID) (*conn, direction) {
 	return nil, dirOriginal
 }
 
-func (ct *ConnTrack) insertRedirectConn(pkt *PacketBuffer, hook Hook, port uint16, address tcpip.Address) *conn {
+// insertRedirectConn inserts a connection redirecting pkt to address and port.
+// It returns the connection, or nil if pkt cannot be tracked. It returns false
+// if the table is full, in which case pkt should be dropped.
+func (ct *ConnTrack) insertRedirectConn(pkt *PacketBuffer, hook Hook, port uint16, address tcpip.Address) (*conn, bool) {
 	tid, err := packetToTupleID(pkt)
 	if err != nil {
-		return nil
+		return nil, true
 	}
 	if hook != Prerouting && hook != Output {
-		return nil
+		return nil, true
 	}
 
 	// Create a new connection and change the port as per the iptables
@@ -292,17 +442,28 @@ func (ct *ConnTrack) insertRedirectConn(pkt *PacketBuffer, hook Hook, port uint1
 		manip = manipDstOutput
 	}
 	conn := newConn(tid, replyTID, manip, hook)
-	ct.insertConn(conn)
-	return conn
+	if !ct.insertConn(conn) {
+		return nil, false
+	}
+	return conn, true
 }
 
-// insertConn inserts conn into the appropriate table bucket.
-func (ct *ConnTrack) insertConn(conn *conn) {
-	// Lock the buckets in the correct order.
-	tupleBucket := ct.bucket(conn.original.tupleID)
-	replyBucket := ct.bucket(conn.reply.tupleID)
+// insertConn inserts conn into the appropriate table bucket. It returns false
+// if the table is full.
+func (ct *ConnTrack) insertConn(conn *conn) bool {
 	ct.mu.RLock()
 	defer ct.mu.RUnlock()
+
+	// Reserve a place for the connection.
+	if n := atomic.AddInt64(&ct.connections, 1); ct.settings.MaxConnections != 0 && n > int64(ct.settings.MaxConnections) {
+		atomic.AddInt64(&ct.connections, -1)
+		atomic.AddUint64(&ct.drop, 1)
+		return false
+	}
+
+	// Lock the buckets in the correct order.
+	tupleBucket := ct.bucketLocked(conn.original.tupleID)
+	replyBucket := ct.bucketLocked(conn.reply.tupleID)
 	if tupleBucket < replyBucket {
 		ct.buckets[tupleBucket].mu.Lock()
 		ct.buckets[replyBucket].mu.Lock()
@@ -324,10 +485,15 @@ func (ct *ConnTrack) insertConn(conn *conn) {
 		}
 	}
 
-	if !alreadyInserted {
+	if alreadyInserted {
+		atomic.AddInt64(&ct.connections, -1)
+		atomic.AddUint64(&ct.insertFailed, 1)
+	} else {
 		// Add the tuple to the map.
+		conn.id = atomic.AddUint32(&ct.lastID, 1)
 		ct.buckets[tupleBucket].tuples.PushFront(&conn.original)
 		ct.buckets[replyBucket].tuples.PushFront(&conn.reply)
+		atomic.AddUint64(&ct.insert, 1)
 	}
 
 	// Unlocking can happen in any order.
@@ -335,6 +501,7 @@ func (ct *ConnTrack) insertConn(conn *conn) {
 	if tupleBucket != replyBucket {
 		ct.buckets[replyBucket].mu.Unlock()
 	}
+	return true
 }
 
 // handlePacketPrerouting manipulates ports for packets in Prerouting hook.
@@ -461,6 +628,9 @@ func (ct *ConnTrack) handlePacket(pkt *PacketBuffer, hook Hook, gso *GSO, r *Rou
 
 	// Mark the connection as having been used recently so it isn't reaped.
 	conn.lastUsed = time.Now()
+	if dir == dirReply {
+		conn.seenReply = true
+	}
 	// Update connection state.
 	conn.updateLocked(header.TCP(pkt.TransportHeader().View()), hook)
 
@@ -472,17 +642,18 @@ func (ct *ConnTrack) handlePacket(pkt *PacketBuffer, hook Hook, gso *GSO, r *Rou
 // already a connection for pkt.
 //
 // This should be called after traversing iptables rules only, to ensure that
-// pkt.NatDone is set correctly.
-func (ct *ConnTrack) maybeInsertNoop(pkt *PacketBuffer, hook Hook) {
+// pkt.NatDone is set correctly. It returns false if the table is full, in which
+// case pkt should be dropped.
+func (ct *ConnTrack) maybeInsertNoop(pkt *PacketBuffer, hook Hook) bool {
 	// If there were a rule applying to this packet, it would be marked
 	// with NatDone.
 	if pkt.NatDone {
-		return
+		return true
 	}
 
 	// We only track TCP connections.
 	if pkt.Network().TransportProtocol() != header.TCPProtocolNumber {
-		return
+		return true
 	}
 
 	// This is the first packet we're seeing for the TCP connection. Insert
@@ -490,15 +661,17 @@ func (ct *ConnTrack) maybeInsertNoop(pkt *PacketBuffer, hook Hook) {
 	// get NATed, breaking the connection.
 	tid, err := packetToTupleID(pkt)
 	if err != nil {
-		return
+		return true
 	}
 	conn := newConn(tid, tid.reply(), manipNone, hook)
 	conn.updateLocked(header.TCP(pkt.TransportHeader().View()), hook)
-	ct.insertConn(conn)
+	return ct.insertConn(conn)
 }
 
-// bucket gets the conntrack bucket for a tupleID.
-func (ct *ConnTrack) bucket(id tupleID) int {
+// bucketLocked gets the conntrack bucket for a tupleID.
+//
+// Precondition: ct.mu must be locked.
+func (ct *ConnTrack) bucketLocked(id tupleID) int {
 	h := jenkins.Sum32(ct.seed)
 	h.Write([]byte(id.srcAddr))
 	h.Write([]byte(id.dstAddr))
@@ -511,9 +684,7 @@ func (ct *ConnTrack) bucket(id tupleID) int {
 	h.Write([]byte(shortBuf))
 	binary.LittleEndian.PutUint16(shortBuf, uint16(id.netProto))
 	h.Write([]byte(shortBuf))
-	ct.mu.RLock()
-	defer ct.mu.RUnlock()
-	return int(h.Sum32()) % len(ct.buckets)
+	return int(h.Sum32() % uint32(len(ct.buckets)))
 }
 
 // reapUnused deletes timed out entries from the conntrack map. The rules for
@@ -546,10 +717,16 @@ func (ct *ConnTrack) reapUnused(start int, prevInterval time.Duration) (int, tim
 	var idx int
 	ct.mu.RLock()
 	defer ct.mu.RUnlock()
-	for i := 0; i < len(ct.buckets)/fractionPerReaping; i++ {
+	n := len(ct.buckets) / fractionPerReaping
+	if n == 0 {
+		n = 1
+	}
+	for i := 0; i < n; i++ {
 		idx = (i + start) % len(ct.buckets)
 		ct.buckets[idx].mu.Lock()
-		for tuple := ct.buckets[idx].tuples.Front(); tuple != nil; tuple = tuple.Next() {
+		var next *tuple
+		for tuple := ct.buckets[idx].tuples.Front(); tuple != nil; tuple = next {
+			next = tuple.Next()
 			checked++
 			if ct.reapTupleLocked(tuple, idx, now) {
 				expired++
@@ -584,34 +761,35 @@ func (ct *ConnTrack) reapUnused(start int, prevInterval time.Duration) (int, tim
 // * ct.mu is locked for reading.
 // * bucket is locked.
 func (ct *ConnTrack) reapTupleLocked(tuple *tuple, bucket int, now time.Time) bool {
-	if !tuple.conn.timedOut(now) {
+	if !tuple.conn.timedOut(now, &ct.settings) {
 		return false
 	}
 
-	// To maintain lock order, we can only reap these tuples if the reply
-	// appears later in the table.
-	replyBucket := ct.bucket(tuple.reply())
-	if bucket > replyBucket {
+	// To maintain lock order, we can only reap these tuples if the other
+	// tuple appears later in the table.
+	other := &tuple.conn.reply
+	if tuple.direction == dirReply {
+		other = &tuple.conn.original
+	}
+	otherBucket := ct.bucketLocked(other.tupleID)
+	if bucket > otherBucket {
 		return true
 	}
 
 	// Don't re-lock if both tuples are in the same bucket.
-	differentBuckets := bucket != replyBucket
+	differentBuckets := bucket != otherBucket
 	if differentBuckets {
-		ct.buckets[replyBucket].mu.Lock()
+		ct.buckets[otherBucket].mu.Lock()
 	}
 
 	// We have the buckets locked and can remove both tuples.
-	if tuple.direction == dirOriginal {
-		ct.buckets[replyBucket].tuples.Remove(&tuple.conn.reply)
-	} else {
-		ct.buckets[replyBucket].tuples.Remove(&tuple.conn.original)
-	}
+	ct.buckets[otherBucket].tuples.Remove(other)
 	ct.buckets[bucket].tuples.Remove(tuple)
+	atomic.AddInt64(&ct.connections, -1)
 
 	// Don't re-unlock if both tuples are in the same bucket.
 	if differentBuckets {
-		ct.buckets[replyBucket].mu.Unlock()
+		ct.buckets[otherBucket].mu.Unlock()
 	}
 
 	return true
@@ -639,3 +817,184 @@ func (ct *ConnTrack) originalDst(epID TransportEndpointID, netProto tcpip.Networ
 
 	return conn.original.dstAddr, conn.original.dstPort, nil
 }
+
+// init initializes the table of connections.
+func (ct *ConnTrack) init() {
+	ct.mu.Lock()
+	defer ct.mu.Unlock()
+	ct.buckets = make([]bucket, ct.settings.Buckets)
+}
+
+// Settings returns the connection tracking settings.
+func (ct *ConnTrack) Settings() ConnTrackSettings {
+	ct.mu.RLock()
+	defer ct.mu.RUnlock()
+	return ct.settings
+}
+
+// SetSettings sets the connection tracking settings. The table is rehashed if
+// the number of buckets changes.
+//
+// Lowering MaxConnections below the number of tracked connections doesn't
+// delete any, but no new connection is tracked until enough of them expire.
+func (ct *ConnTrack) SetSettings(settings ConnTrackSettings) *tcpip.Error {
+	if settings.Buckets <= 0 || settings.MaxConnections < 0 ||
+		settings.TCPSynSentTimeout < 0 || settings.TCPEstablishedTimeout < 0 ||
+		settings.TCPTimeWaitTimeout < 0 || settings.TCPCloseTimeout < 0 {
+		return tcpip.ErrInvalidOptionValue
+	}
+
+	ct.mu.Lock()
+	defer ct.mu.Unlock()
+	resize := ct.buckets != nil && settings.Buckets != ct.settings.Buckets
+	ct.settings = settings
+	if resize {
+		// Rehash all tuples. Holding the write lock guarantees that no
+		// bucket is in use.
+		old := ct.buckets
+		ct.buckets = make([]bucket, settings.Buckets)
+		for i := range old {
+			for t := old[i].tuples.Front(); t != nil; t = old[i].tuples.Front() {
+				old[i].tuples.Remove(t)
+				ct.buckets[ct.bucketLocked(t.tupleID)].tuples.PushBack(t)
+			}
+		}
+	}
+	return nil
+}
+
+// Stats returns the connection tracking statistics.
+func (ct *ConnTrack) Stats() ConnTrackStats {
+	return ConnTrackStats{
+		Connections:  int(atomic.LoadInt64(&ct.connections)),
+		Found:        atomic.LoadUint64(&ct.found),
+		Insert:       atomic.LoadUint64(&ct.insert),
+		InsertFailed: atomic.LoadUint64(&ct.insertFailed),
+		Drop:         atomic.LoadUint64(&ct.drop),
+	}
+}
+
+// entryLocked returns a snapshot of the connection of tuple, or false if it
+// timed out.
+//
+// Preconditions:
+// * ct.mu is locked for reading.
+// * tuple.direction is dirOriginal.
+func (ct *ConnTrack) entryLocked(tuple *tuple, now time.Time) (ConnTrackEntry, bool) {
+	cn := tuple.conn
+	cn.mu.Lock()
+	defer cn.mu.Unlock()
+	timeout := cn.timeoutLocked(&ct.settings) - now.Sub(cn.lastUsed)
+	if timeout < 0 {
+		return ConnTrackEntry{}, false
+	}
+	return ConnTrackEntry{
+		ID:         cn.id,
+		NetProto:   cn.original.netProto,
+		TransProto: cn.original.transProto,
+		Original: ConnTrackTuple{
+			SrcAddr: cn.original.srcAddr,
+			SrcPort: cn.original.srcPort,
+			DstAddr: cn.original.dstAddr,
+			DstPort: cn.original.dstPort,
+		},
+		Reply: ConnTrackTuple{
+			SrcAddr: cn.reply.srcAddr,
+			SrcPort: cn.reply.srcPort,
+			DstAddr: cn.reply.dstAddr,
+			DstPort: cn.reply.dstPort,
+		},
+		TCPState:  cn.tcb.State(),
+		SeenReply: cn.seenReply,
+		DstNAT:    cn.manip != manipNone,
+		Timeout:   timeout,
+	}, true
+}
+
+// Entries returns a snapshot of the tracked connections.
+func (ct *ConnTrack) Entries() []ConnTrackEntry {
+	now := time.Now()
+	ct.mu.RLock()
+	defer ct.mu.RUnlock()
+	var entries []ConnTrackEntry
+	for i := range ct.buckets {
+		ct.buckets[i].mu.Lock()
+		for t := ct.buckets[i].tuples.Front(); t != nil; t = t.Next() {
+			if t.direction != dirOriginal {
+				continue
+			}
+			if entry, ok := ct.entryLocked(t, now); ok {
+				entries = append(entries, entry)
+			}
+		}
+		ct.buckets[i].mu.Unlock()
+	}
+	return entries
+}
+
+// DeleteEntries deletes the tracked connections for which match returns true,
+// and returns the number of deleted connections.
+func (ct *ConnTrack) DeleteEntries(match func(*ConnTrackEntry) bool) int {
+	now := time.Now()
+	ct.mu.RLock()
+	defer ct.mu.RUnlock()
+
+	// Find the connections to delete first, since buckets can only be locked
+	// in order.
+	var conns []*conn
+	for i := range ct.buckets {
+		ct.buckets[i].mu.Lock()
+		for t := ct.buckets[i].tuples.Front(); t != nil; t = t.Next() {
+			if t.direction != dirOriginal {
+				continue
+			}
+			if entry, ok := ct.entryLocked(t, now); ok && match(&entry) {
+				conns = append(conns, t.conn)
+			}
+		}
+		ct.buckets[i].mu.Unlock()
+	}
+
+	deleted := 0
+	for _, cn := range conns {
+		if ct.removeConnLocked(cn) {
+			deleted++
+		}
+	}
+	return deleted
+}
+
+// removeConnLocked removes the tuples of cn from the table. It returns false
+// if cn was already removed.
+//
+// Precondition: ct.mu is locked for reading.
+func (ct *ConnTrack) removeConnLocked(cn *conn) bool {
+	origBucket := ct.bucketLocked(cn.original.tupleID)
+	replyBucket := ct.bucketLocked(cn.reply.tupleID)
+	first, second := origBucket, replyBucket
+	if first > second {
+		first, second = second, first
+	}
+	ct.buckets[first].mu.Lock()
+	defer ct.buckets[first].mu.Unlock()
+	if first != second {
+		ct.buckets[second].mu.Lock()
+		defer ct.buckets[second].mu.Unlock()
+	}
+
+	// The connection may have been reaped concurrently.
+	found := false
+	for t := ct.buckets[origBucket].tuples.Front(); t != nil; t = t.Next() {
+		if t == &cn.original {
+			found = true
+			break
+		}
+	}
+	if !found {
+		return false
+	}
+	ct.buckets[origBucket].tuples.Remove(&cn.original)
+	ct.buckets[replyBucket].tuples.Remove(&cn.reply)
+	atomic.AddInt64(&ct.connections, -1)
+	return true
+}
@@ -180,7 +180,8 @@ func DefaultTables() *IPTables {
 			Output:     {MangleID, NATID, FilterID},
 		},
 		connections: ConnTrack{
-			seed: generateRandUint32(),
+			seed:     generateRandUint32(),
+			settings: DefaultConnTrackSettings(),
 		},
 		reaperDone: make(chan struct{}, 1),
 	}
@@ -235,7 +236,7 @@ func (it *IPTables) ReplaceTable(id TableID, table Table, ipv6 bool) *tcpip.Erro
 	// If iptables is being enabled, initialize the conntrack table and
 	// reaper.
 	if !it.modified {
-		it.connections.buckets = make([]bucket, numBuckets)
+		it.connections.init()
 		it.startReaper(reaperDelay)
 	}
 	it.modified = true
@@ -339,8 +340,9 @@ func (it *IPTables) Check(hook Hook, pkt *PacketBuffer, gso *GSO, r *Route, prer
 	// From the iptables documentation: "If there is no rule, a `null'
 	// binding is created: this usually does not map the packet, but exists
 	// to ensure we don't map another stream over an existing one."
-	if shouldTrack {
-		it.connections.maybeInsertNoop(pkt, hook)
+	if shouldTrack && !it.connections.maybeInsertNoop(pkt, hook) {
+		// The connection tracking table is full.
+		return false
 	}
 
 	// Every table returned Accept.
@@ -491,3 +493,8 @@ func (it *IPTables) OriginalDst(epID TransportEndpointID, netProto tcpip.Network
 	}
 	return it.connections.originalDst(epID, netProto)
 }
+
+// ConnTrack returns the connection tracking table.
+func (it *IPTables) ConnTrack() *ConnTrack {
+	return &it.connections
+}
@@ -83,6 +83,64 @@ func (*ReturnTarget) Action(*PacketBuffer, *ConnTrack, Hook, *GSO, *Route, tcpip
 	return RuleReturn, 0
 }
 
+// TProxyTarget diverts packets to a local transparent proxy without modifying
+// them. The packets are delivered locally regardless of their destination, to
+// the endpoint of their connection if it exists or to the proxy endpoint
+// bound to Addr and Port otherwise. Only endpoints with IP_TRANSPARENT set
+// accept diverted packets.
+//
+// TProxyTarget is only valid in the Prerouting hook.
+type TProxyTarget struct {
+	// Addr is the local address of the proxy. If empty, the primary address of
+	// the incoming interface is used. It is immutable.
+	Addr tcpip.Address
+
+	// Port is the local port of the proxy. If zero, the destination port of
+	// the packet is used. It is immutable.
+	Port uint16
+
+	// NetworkProtocol is the network protocol the target is used with. It
+	// is immutable.
+	NetworkProtocol tcpip.NetworkProtocolNumber
+}
+
+// Action implements Target.Action.
+func (tt *TProxyTarget) Action(pkt *PacketBuffer, _ *ConnTrack, hook Hook, _ *GSO, _ *Route, address tcpip.Address) (RuleVerdict, int) {
+	// Sanity check.
+	if tt.NetworkProtocol != pkt.NetworkProtocolNumber {
+		panic(fmt.Sprintf(
+			"TProxyTarget.Action with NetworkProtocol %d called on packet with NetworkProtocolNumber %d",
+			tt.NetworkProtocol, pkt.NetworkProtocolNumber))
+	}
+
+	// Drop the packet if it is not incoming or if network and transport
+	// header are not set.
+	if hook != Prerouting || pkt.NetworkHeader().View().IsEmpty() || pkt.TransportHeader().View().IsEmpty() {
+		return RuleDrop, 0
+	}
+
+	var port uint16
+	switch pkt.TransportProtocolNumber {
+	case header.TCPProtocolNumber:
+		port = header.TCP(pkt.TransportHeader().View()).DestinationPort()
+	case header.UDPProtocolNumber:
+		port = header.UDP(pkt.TransportHeader().View()).DestinationPort()
+	default:
+		return RuleDrop, 0
+	}
+	if tt.Port != 0 {
+		port = tt.Port
+	}
+	if len(tt.Addr) != 0 {
+		address = tt.Addr
+	}
+	pkt.TProxy = TProxyInfo{
+		Addr: address,
+		Port: port,
+	}
+	return RuleAccept, 0
+}
+
 // RedirectTarget redirects the packet to this machine by modifying the
 // destination port/IP. Outgoing packets are redirected to the loopback device,
 // and incoming packets are redirected to the incoming interface (rather than
@@ -173,7 +231,12 @@ func (rt *RedirectTarget) Action(pkt *PacketBuffer, ct *ConnTrack, hook Hook, gs
 		// Set up conection for matching NAT rule. Only the first
 		// packet of the connection comes here. Other packets will be
 		// manipulated in connection tracking.
-		if conn := ct.insertRedirectConn(pkt, hook, rt.Port, address); conn != nil {
+		conn, ok := ct.insertRedirectConn(pkt, hook, rt.Port, address)
+		if !ok {
+			// The connection tracking table is full.
+			return RuleDrop, 0
+		}
+		if conn != nil {
 			ct.handlePacket(pkt, hook, gso, r)
 		}
 	default:
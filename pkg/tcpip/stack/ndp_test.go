@@ -151,6 +151,12 @@ type ndpDNSSLEvent struct {
 	lifetime    time.Duration
 }
 
+type ndpPref64Event struct {
+	nicID    tcpip.NICID
+	prefix   tcpip.Subnet
+	lifetime time.Duration
+}
+
 type ndpDHCPv6Event struct {
 	nicID         tcpip.NICID
 	configuration ipv6.DHCPv6ConfigurationFromNDPRA
@@ -169,6 +175,7 @@ type ndpDispatcher struct {
 	autoGenAddrC         chan ndpAutoGenAddrEvent
 	rdnssC               chan ndpRDNSSEvent
 	dnsslC               chan ndpDNSSLEvent
+	pref64C              chan ndpPref64Event
 	routeTable           []tcpip.Route
 	dhcpv6ConfigurationC chan ndpDHCPv6Event
 }
@@ -288,6 +295,17 @@ func (n *ndpDispatcher) OnDNSSearchListOption(nicID tcpip.NICID, domainNames []s
 	}
 }
 
+// Implements ipv6.NDPDispatcher.OnPref64Option.
+func (n *ndpDispatcher) OnPref64Option(nicID tcpip.NICID, prefix tcpip.Subnet, lifetime time.Duration) {
+	if n.pref64C != nil {
+		n.pref64C <- ndpPref64Event{
+			nicID,
+			prefix,
+			lifetime,
+		}
+	}
+}
+
 // Implements ipv6.NDPDispatcher.OnDHCPv6Configuration.
 func (n *ndpDispatcher) OnDHCPv6Configuration(nicID tcpip.NICID, configuration ipv6.DHCPv6ConfigurationFromNDPRA) {
 	if c := n.dhcpv6ConfigurationC; c != nil {
@@ -4583,6 +4601,68 @@ func TestNDPDNSSearchListDispatch(t *testing.T) {
 	}
 }
 
+// TestNDPPref64Dispatch tests that the integrator is informed when an NDP
+// PREF64 option with a valid prefix is received.
+func TestNDPPref64Dispatch(t *testing.T) {
+	const nicID = 1
+
+	ndpDisp := ndpDispatcher{
+		pref64C: make(chan ndpPref64Event, 2),
+	}
+	e := channel.New(0, 1280, linkAddr1)
+	s := stack.New(stack.Options{
+		NetworkProtocols: []stack.NetworkProtocolFactory{ipv6.NewProtocolWithOptions(ipv6.Options{
+			NDPConfigs: ipv6.NDPConfigurations{
+				HandleRAs: true,
+			},
+			NDPDisp: &ndpDisp,
+		})},
+	})
+	if err := s.CreateNIC(nicID, e); err != nil {
+		t.Fatalf("CreateNIC(%d, _) = %s", nicID, err)
+	}
+
+	optSer := header.NDPOptionsSerializer{
+		// Scaled lifetime = 225 (1800s), PLC = 0 (/96).
+		header.NDPPref64([]byte{
+			0x07, 0x08,
+			0x00, 0x64, 0xff, 0x9b, 0, 0, 0, 0, 0, 0, 0, 0,
+		}),
+		// An invalid Prefix Length Code which must be ignored.
+		header.NDPPref64([]byte{
+			0x07, 0x0f,
+			0x00, 0x64, 0xff, 0x9b, 0, 0, 0, 0, 0, 0, 0, 0,
+		}),
+	}
+	e.InjectInbound(header.IPv6ProtocolNumber, raBufWithOpts(llAddr1, 0, optSer))
+
+	wantPrefix := tcpip.AddressWithPrefix{
+		Address:   "\x00\x64\xff\x9b\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00",
+		PrefixLen: 96,
+	}.Subnet()
+	select {
+	case pref64 := <-ndpDisp.pref64C:
+		if pref64.nicID != nicID {
+			t.Errorf("got pref64 nicID = %d, want = %d", pref64.nicID, nicID)
+		}
+		if pref64.prefix != wantPrefix {
+			t.Errorf("got pref64 prefix = %s, want = %s", pref64.prefix, wantPrefix)
+		}
+		if want := 1800 * time.Second; pref64.lifetime != want {
+			t.Errorf("got pref64 lifetime = %s, want = %s", pref64.lifetime, want)
+		}
+	default:
+		t.Fatal("expected a PREF64 event")
+	}
+
+	// The option with an invalid Prefix Length Code should be ignored.
+	select {
+	case e := <-ndpDisp.pref64C:
+		t.Fatalf("unexpectedly got a PREF64 event: %+v", e)
+	default:
+	}
+}
+
 // TestCleanupNDPState tests that all discovered routers and prefixes, and
 // auto-generated addresses are invalidated when a NIC becomes a router.
 func TestCleanupNDPState(t *testing.T) {
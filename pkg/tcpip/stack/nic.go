@@ -49,6 +49,12 @@ type NIC struct {
 	// Must be accessed using atomic operations.
 	enabled uint32
 
+	// mtu is the MTU of the NIC as set by setMTU, or 0 if the MTU of the
+	// link endpoint is used.
+	//
+	// Must be accessed using atomic operations.
+	mtu uint32
+
 	// linkResQueue holds packets that are waiting for link resolution to
 	// complete.
 	linkResQueue packetsPendingLinkResolution
@@ -62,6 +68,9 @@ type NIC struct {
 		// packetEPs is protected by mu, but the contained packetEndpointList are
 		// not.
 		packetEPs map[tcpip.NetworkProtocolNumber]*packetEndpointList
+		// qdisc is the queueing discipline of the packets written to the NIC,
+		// or nil if they are written directly to the link endpoint.
+		qdisc QueueingDiscipline
 	}
 }
 
@@ -276,11 +285,35 @@ func (n *NIC) remove() *tcpip.Error {
 		ep.Close()
 	}
 
+	if n.mu.qdisc != nil {
+		n.mu.qdisc.Close()
+		n.mu.qdisc = nil
+	}
+
 	// Detach from link endpoint, so no packet comes in.
 	n.LinkEndpoint.Attach(nil)
 	return nil
 }
 
+// setQueueingDiscipline sets the qdisc of the NIC and closes the previous one.
+func (n *NIC) setQueueingDiscipline(qdisc QueueingDiscipline) {
+	n.mu.Lock()
+	old := n.mu.qdisc
+	n.mu.qdisc = qdisc
+	n.mu.Unlock()
+
+	if old != nil {
+		old.Close()
+	}
+}
+
+// queueingDiscipline returns the qdisc of the NIC.
+func (n *NIC) queueingDiscipline() QueueingDiscipline {
+	n.mu.RLock()
+	defer n.mu.RUnlock()
+	return n.mu.qdisc
+}
+
 // setPromiscuousMode enables or disables promiscuous mode.
 func (n *NIC) setPromiscuousMode(enable bool) {
 	n.mu.Lock()
@@ -296,6 +329,26 @@ func (n *NIC) Promiscuous() bool {
 	return rv
 }
 
+// MTU implements NetworkInterface.
+//
+// It shadows the MTU of the link endpoint, which is the upper bound of the MTU
+// of the NIC.
+func (n *NIC) MTU() uint32 {
+	if mtu := atomic.LoadUint32(&n.mtu); mtu != 0 {
+		return mtu
+	}
+	return n.LinkEndpoint.MTU()
+}
+
+// setMTU sets the MTU of the NIC.
+func (n *NIC) setMTU(mtu uint32) *tcpip.Error {
+	if mtu == 0 || mtu > n.LinkEndpoint.MTU() {
+		return tcpip.ErrInvalidOptionValue
+	}
+	atomic.StoreUint32(&n.mtu, mtu)
+	return nil
+}
+
 // IsLoopback implements NetworkInterface.
 func (n *NIC) IsLoopback() bool {
 	return n.LinkEndpoint.Capabilities()&CapabilityLoopback != 0
@@ -336,13 +389,21 @@ func (n *NIC) WritePacketToRemote(remoteLinkAddr tcpip.LinkAddress, gso *GSO, pr
 }
 
 func (n *NIC) writePacket(r RouteInfo, gso *GSO, protocol tcpip.NetworkProtocolNumber, pkt *PacketBuffer) *tcpip.Error {
-	// WritePacket takes ownership of pkt, calculate numBytes first.
-	numBytes := pkt.Size()
-
 	pkt.EgressRoute = r
 	pkt.GSOOptions = gso
 	pkt.NetworkProtocolNumber = protocol
-	if err := n.LinkEndpoint.WritePacket(r, gso, protocol, pkt); err != nil {
+	if qdisc := n.queueingDiscipline(); qdisc != nil {
+		return qdisc.WritePacket(pkt)
+	}
+	return n.WriteQueuedPacket(pkt)
+}
+
+// WriteQueuedPacket implements QueueingDisciplineWriter.
+func (n *NIC) WriteQueuedPacket(pkt *PacketBuffer) *tcpip.Error {
+	// WritePacket takes ownership of pkt, calculate numBytes first.
+	numBytes := pkt.Size()
+
+	if err := n.LinkEndpoint.WritePacket(pkt.EgressRoute, pkt.GSOOptions, pkt.NetworkProtocolNumber, pkt); err != nil {
 		return err
 	}
 
@@ -363,6 +424,20 @@ func (n *NIC) writePackets(r RouteInfo, gso *GSO, protocol tcpip.NetworkProtocol
 		pkt.NetworkProtocolNumber = protocol
 	}
 
+	if qdisc := n.queueingDiscipline(); qdisc != nil {
+		// The qdisc takes ownership of each packet, so it must be removed from
+		// the list first.
+		writtenPackets := 0
+		for pkt := pkts.Front(); pkt != nil; pkt = pkts.Front() {
+			pkts.Remove(pkt)
+			if err := qdisc.WritePacket(pkt); err != nil {
+				return writtenPackets, err
+			}
+			writtenPackets++
+		}
+		return writtenPackets, nil
+	}
+
 	writtenPackets, err := n.LinkEndpoint.WritePackets(r, gso, pkts, protocol)
 	n.stats.Tx.Packets.IncrementBy(uint64(writtenPackets))
 	writtenBytes := 0
@@ -644,6 +719,22 @@ func (n *NIC) leaveGroup(protocol tcpip.NetworkProtocolNumber, addr tcpip.Addres
 	return gep.LeaveGroup(addr)
 }
 
+// changeGroupSourceFilter replaces the source filter of one of the joins of
+// the given multicast group.
+func (n *NIC) changeGroupSourceFilter(protocol tcpip.NetworkProtocolNumber, addr tcpip.Address, old, new *tcpip.MulticastSourceFilter) *tcpip.Error {
+	ep, ok := n.networkEndpoints[protocol]
+	if !ok {
+		return tcpip.ErrNotSupported
+	}
+
+	gep, ok := ep.(GroupAddressableEndpoint)
+	if !ok {
+		return tcpip.ErrNotSupported
+	}
+
+	return gep.ChangeGroupSourceFilter(addr, old, new)
+}
+
 // isInGroup returns true if n has joined the multicast group addr.
 func (n *NIC) isInGroup(addr tcpip.Address) bool {
 	for _, ep := range n.networkEndpoints {
@@ -771,6 +862,12 @@ func (n *NIC) DeliverOutboundPacket(remote, local tcpip.LinkAddress, protocol tc
 func (n *NIC) DeliverTransportPacket(protocol tcpip.TransportProtocolNumber, pkt *PacketBuffer) TransportPacketDisposition {
 	state, ok := n.stack.transportProtocols[protocol]
 	if !ok {
+		// Raw sockets may be opened for protocols the stack does not
+		// implement. As in net/ipv4/ip_input.c:ip_protocol_deliver_rcu, the
+		// packet is only considered unreachable if no raw socket took it.
+		if n.stack.demux.deliverRawPacket(protocol, pkt) {
+			return TransportPacketHandled
+		}
 		n.stack.stats.UnknownProtocolRcvdPackets.Increment()
 		return TransportPacketProtocolUnreachable
 	}
@@ -851,6 +948,8 @@ func (n *NIC) DeliverTransportPacket(protocol tcpip.TransportProtocolNumber, pkt
 // DeliverTransportControlPacket delivers control packets to the appropriate
 // transport protocol endpoint.
 func (n *NIC) DeliverTransportControlPacket(local, remote tcpip.Address, net tcpip.NetworkProtocolNumber, trans tcpip.TransportProtocolNumber, typ ControlType, extra uint32, pkt *PacketBuffer) {
+	n.stack.demux.deliverRawControlPacket(net, trans, typ, extra, pkt)
+
 	state, ok := n.stack.transportProtocols[trans]
 	if !ok {
 		return
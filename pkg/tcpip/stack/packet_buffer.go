@@ -122,6 +122,10 @@ type PacketBuffer struct {
 
 	// NetworkPacketInfo holds an incoming packet's network-layer information.
 	NetworkPacketInfo NetworkPacketInfo
+
+	// TProxy is set by the TPROXY target on incoming packets that are diverted
+	// to a transparent proxy.
+	TProxy TProxyInfo
 }
 
 // NewPacketBuffer creates a new PacketBuffer with opts.
@@ -265,6 +269,7 @@ func (pk *PacketBuffer) Clone() *PacketBuffer {
 		NICID:                        pk.NICID,
 		RXTransportChecksumValidated: pk.RXTransportChecksumValidated,
 		NetworkPacketInfo:            pk.NetworkPacketInfo,
+		TProxy:                       pk.TProxy,
 	}
 }
 
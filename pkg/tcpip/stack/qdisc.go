@@ -0,0 +1,77 @@
+// Copyright 2021 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package stack
+
+import "gvisor.dev/gvisor/pkg/tcpip"
+
+// QueueingDiscipline is a queueing discipline (qdisc) of the packets written
+// to a NIC, as configured with tc(8) on Linux. It may delay, reorder or drop
+// the packets before they are written to the link endpoint of the NIC.
+type QueueingDiscipline interface {
+	// WritePacket takes ownership of pkt and eventually writes it with the
+	// QueueingDisciplineWriter the qdisc was created with, unless the packet
+	// is dropped.
+	//
+	// The EgressRoute, GSOOptions and NetworkProtocolNumber fields of pkt are
+	// set.
+	WritePacket(pkt *PacketBuffer) *tcpip.Error
+
+	// Close drops the queued packets and stops the timers of the qdisc.
+	Close()
+}
+
+// QueueingDisciplineWriter writes the packets released by a qdisc to the link
+// endpoint of a NIC.
+type QueueingDisciplineWriter interface {
+	// WriteQueuedPacket writes pkt to the link endpoint.
+	WriteQueuedPacket(pkt *PacketBuffer) *tcpip.Error
+}
+
+// NewQueueingDisciplineFunc creates a qdisc writing the packets it releases
+// with w. clock must be used to schedule the release of delayed packets.
+type NewQueueingDisciplineFunc func(w QueueingDisciplineWriter, clock tcpip.Clock) QueueingDiscipline
+
+// SetNICQueueingDiscipline sets the qdisc of the packets written to the NIC to
+// one created with newQdisc, and closes the previous one. If newQdisc is nil,
+// packets are written directly to the link endpoint.
+func (s *Stack) SetNICQueueingDiscipline(id tcpip.NICID, newQdisc NewQueueingDisciplineFunc) *tcpip.Error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	nic, ok := s.nics[id]
+	if !ok {
+		return tcpip.ErrUnknownNICID
+	}
+
+	var qdisc QueueingDiscipline
+	if newQdisc != nil {
+		qdisc = newQdisc(nic, s.clock)
+	}
+	nic.setQueueingDiscipline(qdisc)
+	return nil
+}
+
+// NICQueueingDiscipline returns the qdisc of the NIC, or nil if packets are
+// written directly to its link endpoint.
+func (s *Stack) NICQueueingDiscipline(id tcpip.NICID) (QueueingDiscipline, *tcpip.Error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	nic, ok := s.nics[id]
+	if !ok {
+		return nil, tcpip.ErrUnknownNICID
+	}
+	return nic.queueingDiscipline(), nil
+}
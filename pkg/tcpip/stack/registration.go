@@ -81,6 +81,17 @@ type NetworkPacketInfo struct {
 	LocalAddressBroadcast bool
 }
 
+// TProxyInfo holds the local address of the transparent proxy an incoming
+// packet is diverted to by the TPROXY target.
+type TProxyInfo struct {
+	// Addr is the local address of the proxy.
+	Addr tcpip.Address
+
+	// Port is the local port of the proxy. It is zero if the packet is not
+	// diverted.
+	Port uint16
+}
+
 // TransportEndpoint is the interface that needs to be implemented by transport
 // protocol (e.g., tcp, udp) endpoints that can handle packets.
 type TransportEndpoint interface {
@@ -125,6 +136,14 @@ type RawTransportEndpoint interface {
 	//
 	// HandlePacket takes ownership of the packet.
 	HandlePacket(*PacketBuffer)
+
+	// HandleControlPacket is called by the stack when new control (e.g.
+	// ICMP) packets arrive that carry a packet of this endpoint's transport
+	// protocol. pkt.Data holds the offending packet starting at its
+	// transport header.
+	//
+	// HandleControlPacket takes ownership of pkt.
+	HandleControlPacket(typ ControlType, extra uint32, pkt *PacketBuffer)
 }
 
 // PacketEndpoint is the interface that needs to be implemented by packet
@@ -294,6 +313,14 @@ type GroupAddressableEndpoint interface {
 	// LeaveGroup attempts to leave the specified group.
 	LeaveGroup(group tcpip.Address) *tcpip.Error
 
+	// ChangeGroupSourceFilter replaces the source filter of one of the joins
+	// of the specified group. A nil old filter joins the group and a nil new
+	// filter leaves it.
+	//
+	// JoinGroup and LeaveGroup are equivalent to calling
+	// ChangeGroupSourceFilter with an exclude mode filter without sources.
+	ChangeGroupSourceFilter(group tcpip.Address, old, new *tcpip.MulticastSourceFilter) *tcpip.Error
+
 	// IsInGroup returns true if the endpoint is a member of the specified group.
 	IsInGroup(group tcpip.Address) bool
 }
@@ -630,6 +657,119 @@ type ForwardingNetworkProtocol interface {
 	SetForwarding(bool)
 }
 
+// UnicastSourceAndMulticastDestination is a tuple that represents a unicast
+// source address and a multicast destination address.
+type UnicastSourceAndMulticastDestination struct {
+	// Source represents a unicast source address.
+	Source tcpip.Address
+
+	// Destination represents a multicast destination address.
+	Destination tcpip.Address
+}
+
+// MulticastRouteOutgoingInterface represents an outgoing interface in a
+// multicast route.
+type MulticastRouteOutgoingInterface struct {
+	// ID corresponds to the outgoing NIC.
+	ID tcpip.NICID
+
+	// MinTTL represents the minimum TTL/HopLimit a multicast packet must have
+	// to be sent through the outgoing interface.
+	MinTTL uint8
+}
+
+// MulticastRoute is a multicast route.
+type MulticastRoute struct {
+	// ExpectedInputInterface is the interface on which packets using this
+	// route are expected to ingress.
+	ExpectedInputInterface tcpip.NICID
+
+	// OutgoingInterfaces is the set of interfaces that a multicast packet
+	// should be forwarded out of.
+	OutgoingInterfaces []MulticastRouteOutgoingInterface
+}
+
+// MulticastPacketContext is the context in which a multicast packet triggered
+// a multicast forwarding event.
+type MulticastPacketContext struct {
+	// SourceAndDestination contains the unicast source address and the
+	// multicast destination address found in the relevant multicast packet.
+	SourceAndDestination UnicastSourceAndMulticastDestination
+
+	// InputInterface is the interface on which the relevant multicast packet
+	// arrived.
+	InputInterface tcpip.NICID
+}
+
+// MulticastForwardingEventDispatcher is the interface that integrators should
+// implement to handle multicast routing events, e.g. a multicast routing
+// daemon socket.
+type MulticastForwardingEventDispatcher interface {
+	// OnMissingRoute is called when an incoming multicast packet does not
+	// match any installed route. The packet is queued until a route is
+	// installed or until the pending route expires.
+	//
+	// It is only called once for all the packets queued for a pending route.
+	OnMissingRoute(MulticastPacketContext)
+
+	// OnUnexpectedInputInterface is called when a multicast packet arrives at
+	// an interface that does not match the expected input interface of the
+	// installed route. The packet is dropped.
+	OnUnexpectedInputInterface(context MulticastPacketContext, expectedInputInterface tcpip.NICID)
+}
+
+// MulticastForwardingNetworkProtocol is a NetworkProtocol that may forward
+// multicast packets.
+type MulticastForwardingNetworkProtocol interface {
+	NetworkProtocol
+
+	// EnableMulticastForwarding enables multicast forwarding, reporting
+	// multicast forwarding events to disp.
+	//
+	// Returns true if multicast forwarding was already enabled, in which case
+	// disp is ignored.
+	EnableMulticastForwarding(disp MulticastForwardingEventDispatcher) bool
+
+	// DisableMulticastForwarding disables multicast forwarding and removes
+	// all installed and pending multicast routes.
+	DisableMulticastForwarding()
+
+	// AddMulticastRoute adds a route, replacing any route installed for the
+	// same addresses, and forwards the packets pending for it.
+	AddMulticastRoute(UnicastSourceAndMulticastDestination, MulticastRoute) *tcpip.Error
+
+	// RemoveMulticastRoute removes the route installed for the given
+	// addresses.
+	RemoveMulticastRoute(UnicastSourceAndMulticastDestination) *tcpip.Error
+}
+
+// AddressLabel is an entry of the policy table used for source address
+// selection, as per RFC 6724 section 2.1.
+type AddressLabel struct {
+	// Prefix holds the addresses the label applies to.
+	Prefix tcpip.Subnet
+
+	// NIC restricts the entry to addresses used on a NIC. The entry applies to
+	// all NICs if NIC is zero.
+	NIC tcpip.NICID
+
+	// Label is the label of the addresses.
+	Label uint32
+}
+
+// AddressLabelingNetworkProtocol is a NetworkProtocol that selects source
+// addresses using a configurable policy table.
+type AddressLabelingNetworkProtocol interface {
+	NetworkProtocol
+
+	// AddressLabels returns the policy table, in the order entries are
+	// matched.
+	AddressLabels() []AddressLabel
+
+	// SetAddressLabels replaces the policy table.
+	SetAddressLabels([]AddressLabel) *tcpip.Error
+}
+
 // NetworkProtocol is the interface that needs to be implemented by network
 // protocols (e.g., ipv4, ipv6) that want to be part of the networking stack.
 type NetworkProtocol interface {
@@ -860,6 +1000,11 @@ type RawFactory interface {
 	// be used to write arbitrary packets that include the network header.
 	NewUnassociatedEndpoint(stack *Stack, netProto tcpip.NetworkProtocolNumber, transProto tcpip.TransportProtocolNumber, waiterQueue *waiter.Queue) (tcpip.Endpoint, *tcpip.Error)
 
+	// NewAssociatedEndpoint produces endpoints for reading and writing
+	// packets of a transport protocol that is handled by a network protocol
+	// rather than by a registered transport protocol, e.g. IGMP.
+	NewAssociatedEndpoint(stack *Stack, netProto tcpip.NetworkProtocolNumber, transProto tcpip.TransportProtocolNumber, waiterQueue *waiter.Queue) (tcpip.Endpoint, *tcpip.Error)
+
 	// NewPacketEndpoint produces endpoints for reading and writing packets
 	// that include network and (when cooked is false) link layer headers.
 	NewPacketEndpoint(stack *Stack, cooked bool, netProto tcpip.NetworkProtocolNumber, waiterQueue *waiter.Queue) (tcpip.Endpoint, *tcpip.Error)
@@ -0,0 +1,175 @@
+// Copyright 2021 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package stack
+
+import (
+	"sort"
+
+	"gvisor.dev/gvisor/pkg/tcpip"
+	"gvisor.dev/gvisor/pkg/tcpip/header"
+)
+
+// Priorities of the default routing rules, as in Linux.
+const (
+	localRulePriority   = 0
+	mainRulePriority    = 32766
+	defaultRulePriority = 32767
+)
+
+// RoutingRule is a routing policy rule. It selects the routing table used to
+// look up the routes of the packets it matches.
+//
+// The zero value matches all packets and selects the main table.
+type RoutingRule struct {
+	// Priority orders the rules; the rules are evaluated in ascending order
+	// of priority until the table of a matching rule has a route for the
+	// packet.
+	Priority uint32
+
+	// Source, if its prefix is not empty, must contain the source address of
+	// the packet.
+	Source tcpip.Subnet
+
+	// Destination, if its prefix is not empty, must contain the destination
+	// address of the packet.
+	Destination tcpip.Subnet
+
+	// Mark must be equal to the mark of the packet masked with MarkMask.
+	Mark     uint32
+	MarkMask uint32
+
+	// InputNIC, if not zero, must be the NIC a forwarded packet was received
+	// on. Locally generated packets never match it.
+	InputNIC tcpip.NICID
+
+	// OutputNIC, if not zero, must be the NIC the route lookup is restricted
+	// to, e.g. by SO_BINDTODEVICE.
+	OutputNIC tcpip.NICID
+
+	// Invert inverts the result of matching the selectors above.
+	Invert bool
+
+	// Table is the routing table selected by the rule.
+	Table tcpip.RouteTableID
+
+	// SuppressPrefixLength, if SuppressPrefix is true, is the longest prefix
+	// length of the destination of the routes of Table that are ignored,
+	// e.g. 0 to ignore the default routes.
+	SuppressPrefix       bool
+	SuppressPrefixLength int
+}
+
+// RouteLookupOptions holds the packet attributes that routing rules match on,
+// besides the addresses and NIC of the route lookup.
+type RouteLookupOptions struct {
+	// Mark is the mark of the packet, e.g. from SO_MARK.
+	Mark uint32
+
+	// InputNIC is the NIC a forwarded packet was received on. It is zero for
+	// locally generated packets.
+	InputNIC tcpip.NICID
+
+	// Source is the source address of the packet. If empty, the local address
+	// of the route lookup is used.
+	Source tcpip.Address
+
+	// Transparent allows the local address of the route to be an address not
+	// assigned to the stack, e.g. for IP_TRANSPARENT endpoints.
+	Transparent bool
+}
+
+// matches returns true if the rule matches a packet with the given attributes
+// routed through the NIC id.
+func (r *RoutingRule) matches(id tcpip.NICID, src, dst tcpip.Address, opts RouteLookupOptions) bool {
+	return r.Invert != r.selectorsMatch(id, src, dst, opts)
+}
+
+func (r *RoutingRule) selectorsMatch(id tcpip.NICID, src, dst tcpip.Address, opts RouteLookupOptions) bool {
+	if r.Source.Prefix() != 0 && !r.Source.Contains(src) {
+		return false
+	}
+	if r.Destination.Prefix() != 0 && !r.Destination.Contains(dst) {
+		return false
+	}
+	if opts.Mark&r.MarkMask != r.Mark {
+		return false
+	}
+	if r.InputNIC != 0 && r.InputNIC != opts.InputNIC {
+		return false
+	}
+	if r.OutputNIC != 0 && r.OutputNIC != id {
+		return false
+	}
+	return true
+}
+
+// suppresses returns true if route must be ignored when looked up through the
+// rule.
+func (r *RoutingRule) suppresses(route *tcpip.Route) bool {
+	return r.SuppressPrefix && route.Destination.Prefix() <= r.SuppressPrefixLength
+}
+
+// The routing rules used until they are set with SetRoutingRules. As in Linux,
+// IPv6 has no rule for the default table.
+var (
+	defaultIPv4RoutingRules = []RoutingRule{
+		{Priority: localRulePriority, Table: tcpip.LocalRouteTable},
+		{Priority: mainRulePriority, Table: tcpip.MainRouteTable},
+		{Priority: defaultRulePriority, Table: tcpip.DefaultRouteTable},
+	}
+	defaultIPv6RoutingRules = []RoutingRule{
+		{Priority: localRulePriority, Table: tcpip.LocalRouteTable},
+		{Priority: mainRulePriority, Table: tcpip.MainRouteTable},
+	}
+)
+
+// SetRoutingRules sets the routing rules used to select the routing tables of
+// the routes of netProto.
+//
+// This method takes ownership of rules.
+func (s *Stack) SetRoutingRules(netProto tcpip.NetworkProtocolNumber, rules []RoutingRule) {
+	sort.SliceStable(rules, func(i, j int) bool {
+		return rules[i].Priority < rules[j].Priority
+	})
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.routingRules == nil {
+		s.routingRules = make(map[tcpip.NetworkProtocolNumber][]RoutingRule)
+	}
+	s.routingRules[netProto] = rules
+}
+
+// GetRoutingRules returns the routing rules of netProto, in the order they are
+// evaluated.
+func (s *Stack) GetRoutingRules(netProto tcpip.NetworkProtocolNumber) []RoutingRule {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return append([]RoutingRule(nil), s.routingRulesRLocked(netProto)...)
+}
+
+// routingRulesRLocked returns the routing rules of netProto.
+//
+// Precondition: s.mu must be read locked.
+func (s *Stack) routingRulesRLocked(netProto tcpip.NetworkProtocolNumber) []RoutingRule {
+	if rules, ok := s.routingRules[netProto]; ok {
+		return rules
+	}
+	if netProto == header.IPv6ProtocolNumber {
+		return defaultIPv6RoutingRules
+	}
+	return defaultIPv4RoutingRules
+}
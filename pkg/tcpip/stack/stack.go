@@ -394,6 +394,10 @@ type Stack struct {
 	// destination.
 	routeTable []tcpip.Route
 
+	// routingRules holds the routing rules set with SetRoutingRules, sorted by
+	// priority, for each network protocol.
+	routingRules map[tcpip.NetworkProtocolNumber][]RoutingRule
+
 	*ports.PortManager
 
 	// If not nil, then any new endpoints will have this probe function
@@ -809,6 +813,103 @@ func (s *Stack) Forwarding(protocolNum tcpip.NetworkProtocolNumber) bool {
 	return forwardingProtocol.Forwarding()
 }
 
+// multicastForwardingProtocol returns the protocol with the given number if
+// it supports multicast forwarding.
+func (s *Stack) multicastForwardingProtocol(protocolNum tcpip.NetworkProtocolNumber) (MulticastForwardingNetworkProtocol, *tcpip.Error) {
+	protocol, ok := s.networkProtocols[protocolNum]
+	if !ok {
+		return nil, tcpip.ErrUnknownProtocol
+	}
+
+	forwardingProtocol, ok := protocol.(MulticastForwardingNetworkProtocol)
+	if !ok {
+		return nil, tcpip.ErrNotSupported
+	}
+	return forwardingProtocol, nil
+}
+
+// EnableMulticastForwardingForProtocol enables multicast forwarding for the
+// passed protocol, reporting multicast forwarding events to disp.
+//
+// Returns true if multicast forwarding was already enabled, in which case disp
+// is ignored.
+func (s *Stack) EnableMulticastForwardingForProtocol(protocolNum tcpip.NetworkProtocolNumber, disp MulticastForwardingEventDispatcher) (bool, *tcpip.Error) {
+	if disp == nil {
+		return false, tcpip.ErrInvalidOptionValue
+	}
+
+	protocol, err := s.multicastForwardingProtocol(protocolNum)
+	if err != nil {
+		return false, err
+	}
+	return protocol.EnableMulticastForwarding(disp), nil
+}
+
+// DisableMulticastForwardingForProtocol disables multicast forwarding for the
+// passed protocol and removes all of its multicast routes.
+func (s *Stack) DisableMulticastForwardingForProtocol(protocolNum tcpip.NetworkProtocolNumber) *tcpip.Error {
+	protocol, err := s.multicastForwardingProtocol(protocolNum)
+	if err != nil {
+		return err
+	}
+	protocol.DisableMulticastForwarding()
+	return nil
+}
+
+// AddMulticastRoute adds a multicast route to be used by the passed protocol.
+func (s *Stack) AddMulticastRoute(protocolNum tcpip.NetworkProtocolNumber, addresses UnicastSourceAndMulticastDestination, route MulticastRoute) *tcpip.Error {
+	protocol, err := s.multicastForwardingProtocol(protocolNum)
+	if err != nil {
+		return err
+	}
+	return protocol.AddMulticastRoute(addresses, route)
+}
+
+// RemoveMulticastRoute removes a multicast route installed for the passed
+// protocol.
+func (s *Stack) RemoveMulticastRoute(protocolNum tcpip.NetworkProtocolNumber, addresses UnicastSourceAndMulticastDestination) *tcpip.Error {
+	protocol, err := s.multicastForwardingProtocol(protocolNum)
+	if err != nil {
+		return err
+	}
+	return protocol.RemoveMulticastRoute(addresses)
+}
+
+// addressLabelingProtocol returns the AddressLabelingNetworkProtocol for the
+// passed protocol number.
+func (s *Stack) addressLabelingProtocol(protocolNum tcpip.NetworkProtocolNumber) (AddressLabelingNetworkProtocol, *tcpip.Error) {
+	protocol, ok := s.networkProtocols[protocolNum]
+	if !ok {
+		return nil, tcpip.ErrUnknownProtocol
+	}
+
+	labelingProtocol, ok := protocol.(AddressLabelingNetworkProtocol)
+	if !ok {
+		return nil, tcpip.ErrNotSupported
+	}
+	return labelingProtocol, nil
+}
+
+// AddressLabels returns the policy table used by the passed protocol to
+// select source addresses.
+func (s *Stack) AddressLabels(protocolNum tcpip.NetworkProtocolNumber) ([]AddressLabel, *tcpip.Error) {
+	protocol, err := s.addressLabelingProtocol(protocolNum)
+	if err != nil {
+		return nil, err
+	}
+	return protocol.AddressLabels(), nil
+}
+
+// SetAddressLabels replaces the policy table used by the passed protocol to
+// select source addresses.
+func (s *Stack) SetAddressLabels(protocolNum tcpip.NetworkProtocolNumber, labels []AddressLabel) *tcpip.Error {
+	protocol, err := s.addressLabelingProtocol(protocolNum)
+	if err != nil {
+		return err
+	}
+	return protocol.SetAddressLabels(labels)
+}
+
 // SetRouteTable assigns the route table to be used by this stack. It
 // specifies which NIC to use for given destination address ranges.
 //
@@ -872,12 +973,25 @@ func (s *Stack) NewRawEndpoint(transport tcpip.TransportProtocolNumber, network
 
 	t, ok := s.transportProtocols[transport]
 	if !ok {
+		if _, ok := s.demux.protocol[protocolIDs{network, transport}]; ok {
+			return s.rawFactory.NewAssociatedEndpoint(s, network, transport, waiterQueue)
+		}
 		return nil, tcpip.ErrUnknownProtocol
 	}
 
 	return t.proto.NewRawEndpoint(network, waiterQueue)
 }
 
+// DeliverRawPacket delivers pkt to the raw endpoints associated with
+// protocol.
+//
+// It is used by network protocols that handle a transport protocol
+// themselves, e.g. IGMP, as such packets are not delivered through
+// TransportDispatcher.DeliverTransportPacket.
+func (s *Stack) DeliverRawPacket(protocol tcpip.TransportProtocolNumber, pkt *PacketBuffer) {
+	s.demux.deliverRawPacket(protocol, pkt)
+}
+
 // NewPacketEndpoint creates a new packet endpoint listening for the given
 // netProto.
 func (s *Stack) NewPacketEndpoint(cooked bool, netProto tcpip.NetworkProtocolNumber, waiterQueue *waiter.Queue) (tcpip.Endpoint, *tcpip.Error) {
@@ -986,6 +1100,21 @@ func (s *Stack) DisableNIC(id tcpip.NICID) *tcpip.Error {
 	return nil
 }
 
+// SetNICMTU sets the MTU of the given NIC.
+//
+// The MTU must not be zero nor exceed the MTU of the NIC's link endpoint.
+func (s *Stack) SetNICMTU(id tcpip.NICID, mtu uint32) *tcpip.Error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	nic, ok := s.nics[id]
+	if !ok {
+		return tcpip.ErrUnknownNICID
+	}
+
+	return nic.setMTU(mtu)
+}
+
 // CheckNIC checks if a NIC is usable.
 func (s *Stack) CheckNIC(id tcpip.NICID) bool {
 	s.mu.RLock()
@@ -1076,7 +1205,7 @@ func (s *Stack) NICInfo() map[tcpip.NICID]NICInfo {
 	nics := make(map[tcpip.NICID]NICInfo)
 	for id, nic := range s.nics {
 		flags := NICStateFlags{
-			Up:          true, // Netstack interfaces are always up.
+			Up:          nic.Enabled(),
 			Running:     nic.Enabled(),
 			Promiscuous: nic.Promiscuous(),
 			Loopback:    nic.IsLoopback(),
@@ -1092,7 +1221,7 @@ func (s *Stack) NICInfo() map[tcpip.NICID]NICInfo {
 			LinkAddress:       nic.LinkEndpoint.LinkAddress(),
 			ProtocolAddresses: nic.primaryAddresses(),
 			Flags:             flags,
-			MTU:               nic.LinkEndpoint.MTU(),
+			MTU:               nic.MTU(),
 			Stats:             nic.stats,
 			NetworkStats:      netStats,
 			Context:           nic.context,
@@ -1211,10 +1340,15 @@ func (s *Stack) GetMainNICAddress(id tcpip.NICID, protocol tcpip.NetworkProtocol
 	return nic.primaryAddress(protocol), true
 }
 
-func (s *Stack) getAddressEP(nic *NIC, localAddr, remoteAddr tcpip.Address, netProto tcpip.NetworkProtocolNumber) AssignableAddressEndpoint {
+func (s *Stack) getAddressEP(nic *NIC, localAddr, remoteAddr tcpip.Address, netProto tcpip.NetworkProtocolNumber, transparent bool) AssignableAddressEndpoint {
 	if len(localAddr) == 0 {
 		return nic.primaryEndpoint(netProto, remoteAddr)
 	}
+	if transparent {
+		// Transparent routes may use a local address not assigned to the NIC,
+		// as if it were spoofing.
+		return nic.getAddressOrCreateTempInner(netProto, localAddr, true /* createTemp */, CanBePrimaryEndpoint)
+	}
 	return nic.findEndpoint(netProto, localAddr, CanBePrimaryEndpoint)
 }
 
@@ -1309,6 +1443,12 @@ func (s *Stack) findLocalRouteRLocked(localAddressNICID tcpip.NICID, localAddr,
 // remote address is provided, the stack wil use a remote address equal to the
 // local address.
 func (s *Stack) FindRoute(id tcpip.NICID, localAddr, remoteAddr tcpip.Address, netProto tcpip.NetworkProtocolNumber, multicastLoop bool) (*Route, *tcpip.Error) {
+	return s.FindRouteWithOptions(id, localAddr, remoteAddr, netProto, multicastLoop, RouteLookupOptions{})
+}
+
+// FindRouteWithOptions is like FindRoute, but also matches the routing rules
+// against opts to select the routing tables the route is looked up in.
+func (s *Stack) FindRouteWithOptions(id tcpip.NICID, localAddr, remoteAddr tcpip.Address, netProto tcpip.NetworkProtocolNumber, multicastLoop bool, opts RouteLookupOptions) (*Route, *tcpip.Error) {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
 
@@ -1328,7 +1468,7 @@ func (s *Stack) FindRoute(id tcpip.NICID, localAddr, remoteAddr tcpip.Address, n
 	// through the interface if the interface is valid and enabled.
 	if id != 0 && !needRoute {
 		if nic, ok := s.nics[id]; ok && nic.Enabled() {
-			if addressEndpoint := s.getAddressEP(nic, localAddr, remoteAddr, netProto); addressEndpoint != nil {
+			if addressEndpoint := s.getAddressEP(nic, localAddr, remoteAddr, netProto, opts.Transparent); addressEndpoint != nil {
 				return makeRoute(
 					netProto,
 					"", /* gateway */
@@ -1351,40 +1491,63 @@ func (s *Stack) FindRoute(id tcpip.NICID, localAddr, remoteAddr tcpip.Address, n
 
 	canForward := s.Forwarding(netProto) && !header.IsV6LinkLocalAddress(localAddr) && !isLinkLocal
 
-	// Find a route to the remote with the route table.
+	ruleSrc := opts.Source
+	if len(ruleSrc) == 0 {
+		ruleSrc = localAddr
+	}
+
+	// Find a route to the remote with the route tables selected by the
+	// routing rules, in order.
 	var chosenRoute tcpip.Route
-	for _, route := range s.routeTable {
-		if len(remoteAddr) != 0 && !route.Destination.Contains(remoteAddr) {
+	rules := s.routingRulesRLocked(netProto)
+	for i := range rules {
+		rule := &rules[i]
+		if !rule.matches(id, ruleSrc, remoteAddr, opts) {
 			continue
 		}
 
-		nic, ok := s.nics[route.NIC]
-		if !ok || !nic.Enabled() {
-			continue
-		}
+		for _, route := range s.routeTable {
+			if route.Table != rule.Table || rule.suppresses(&route) {
+				continue
+			}
+			if len(remoteAddr) != 0 && !route.Destination.Contains(remoteAddr) {
+				continue
+			}
 
-		if id == 0 || id == route.NIC {
-			if addressEndpoint := s.getAddressEP(nic, localAddr, remoteAddr, netProto); addressEndpoint != nil {
-				var gateway tcpip.Address
-				if needRoute {
-					gateway = route.Gateway
-				}
-				r := constructAndValidateRoute(netProto, addressEndpoint, nic /* outgoingNIC */, nic /* outgoingNIC */, gateway, localAddr, remoteAddr, s.handleLocal, multicastLoop)
-				if r == nil {
-					panic(fmt.Sprintf("non-forwarding route validation failed with route table entry = %#v, id = %d, localAddr = %s, remoteAddr = %s", route, id, localAddr, remoteAddr))
+			nic, ok := s.nics[route.NIC]
+			if !ok || !nic.Enabled() {
+				continue
+			}
+
+			if id == 0 || id == route.NIC {
+				if addressEndpoint := s.getAddressEP(nic, localAddr, remoteAddr, netProto, opts.Transparent); addressEndpoint != nil {
+					var gateway tcpip.Address
+					if needRoute {
+						gateway = route.Gateway
+					}
+					r := constructAndValidateRoute(netProto, addressEndpoint, nic /* outgoingNIC */, nic /* outgoingNIC */, gateway, localAddr, remoteAddr, s.handleLocal, multicastLoop)
+					if r == nil {
+						panic(fmt.Sprintf("non-forwarding route validation failed with route table entry = %#v, id = %d, localAddr = %s, remoteAddr = %s", route, id, localAddr, remoteAddr))
+					}
+					return r, nil
 				}
-				return r, nil
+			}
+
+			// If the stack has forwarding enabled and we haven't found a valid route to
+			// the remote address yet, keep track of the first valid route. We keep
+			// iterating because we prefer routes that let us use a local address that
+			// is assigned to the outgoing interface. There is no requirement to do this
+			// from any RFC but simply a choice made to better follow a strong host
+			// model which the netstack follows at the time of writing.
+			if canForward && chosenRoute == (tcpip.Route{}) {
+				chosenRoute = route
 			}
 		}
 
-		// If the stack has forwarding enabled and we haven't found a valid route to
-		// the remote address yet, keep track of the first valid route. We keep
-		// iterating because we prefer routes that let us use a local address that
-		// is assigned to the outgoing interface. There is no requirement to do this
-		// from any RFC but simply a choice made to better follow a strong host
-		// model which the netstack follows at the time of writing.
-		if canForward && chosenRoute == (tcpip.Route{}) {
-			chosenRoute = route
+		// A route found through a matching rule can only be overridden by
+		// another route from the same table.
+		if chosenRoute != (tcpip.Route{}) {
+			break
 		}
 	}
 
@@ -1405,7 +1568,7 @@ func (s *Stack) FindRoute(id tcpip.NICID, localAddr, remoteAddr tcpip.Address, n
 		// Use the specified NIC to get the local address endpoint.
 		if id != 0 {
 			if aNIC, ok := s.nics[id]; ok {
-				if addressEndpoint := s.getAddressEP(aNIC, localAddr, remoteAddr, netProto); addressEndpoint != nil {
+				if addressEndpoint := s.getAddressEP(aNIC, localAddr, remoteAddr, netProto, opts.Transparent); addressEndpoint != nil {
 					if r := constructAndValidateRoute(netProto, addressEndpoint, aNIC /* localAddressNIC */, nic /* outgoingNIC */, gateway, localAddr, remoteAddr, s.handleLocal, multicastLoop); r != nil {
 						return r, nil
 					}
@@ -1419,7 +1582,7 @@ func (s *Stack) FindRoute(id tcpip.NICID, localAddr, remoteAddr tcpip.Address, n
 			// If an interface is not specified, try to find a NIC that holds the local
 			// address endpoint to construct a route.
 			for _, aNIC := range s.nics {
-				addressEndpoint := s.getAddressEP(aNIC, localAddr, remoteAddr, netProto)
+				addressEndpoint := s.getAddressEP(aNIC, localAddr, remoteAddr, netProto, opts.Transparent)
 				if addressEndpoint == nil {
 					continue
 				}
@@ -1669,6 +1832,15 @@ func (s *Stack) FindTransportEndpoint(netProto tcpip.NetworkProtocolNumber, tran
 	return s.demux.findTransportEndpoint(netProto, transProto, id, nicID)
 }
 
+// FindMigrationEndpoint finds the endpoint that a connection with the given id,
+// queued on the listening endpoint from, is migrated to when from stops
+// listening: another endpoint of the SO_REUSEPORT group of from, selected as
+// for incoming packets of the connection. If from isn't part of such a group
+// with other endpoints, it returns nil.
+func (s *Stack) FindMigrationEndpoint(netProto tcpip.NetworkProtocolNumber, transProto tcpip.TransportProtocolNumber, id TransportEndpointID, bindToDevice tcpip.NICID, from TransportEndpoint) TransportEndpoint {
+	return s.demux.findMigrationEndpoint(netProto, transProto, id, bindToDevice, from)
+}
+
 // RegisterRawTransportEndpoint registers the given endpoint with the stack
 // transport dispatcher. Received packets that match the provided transport
 // protocol will be delivered to the given endpoint.
@@ -1930,6 +2102,19 @@ func (s *Stack) LeaveGroup(protocol tcpip.NetworkProtocolNumber, nicID tcpip.NIC
 	return tcpip.ErrUnknownNICID
 }
 
+// ChangeGroupSourceFilter replaces the source filter of one of the joins of the
+// given multicast group on the given NIC. A nil old filter joins the group and
+// a nil new filter leaves it.
+func (s *Stack) ChangeGroupSourceFilter(protocol tcpip.NetworkProtocolNumber, nicID tcpip.NICID, multicastAddr tcpip.Address, old, new *tcpip.MulticastSourceFilter) *tcpip.Error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if nic, ok := s.nics[nicID]; ok {
+		return nic.changeGroupSourceFilter(protocol, multicastAddr, old, new)
+	}
+	return tcpip.ErrUnknownNICID
+}
+
 // IsInGroup returns true if the NIC with ID nicID has joined the multicast
 // group multicastAddr.
 func (s *Stack) IsInGroup(nicID tcpip.NICID, multicastAddr tcpip.Address) (bool, *tcpip.Error) {
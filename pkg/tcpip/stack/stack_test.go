@@ -674,8 +674,13 @@ func TestDisabledNICsNICInfoAndCheckNIC(t *testing.T) {
 		nicInfo, ok := allNICInfo[nicID]
 		if !ok {
 			t.Errorf("entry for %d missing from allNICInfo = %+v", nicID, allNICInfo)
-		} else if nicInfo.Flags.Running != enabled {
-			t.Errorf("got nicInfo.Flags.Running = %t, want = %t", nicInfo.Flags.Running, enabled)
+		} else {
+			if nicInfo.Flags.Up != enabled {
+				t.Errorf("got nicInfo.Flags.Up = %t, want = %t", nicInfo.Flags.Up, enabled)
+			}
+			if nicInfo.Flags.Running != enabled {
+				t.Errorf("got nicInfo.Flags.Running = %t, want = %t", nicInfo.Flags.Running, enabled)
+			}
 		}
 
 		if got := s.CheckNIC(nicID); got != enabled {
@@ -703,6 +708,46 @@ func TestDisabledNICsNICInfoAndCheckNIC(t *testing.T) {
 	checkNIC(false)
 }
 
+func TestSetNICMTU(t *testing.T) {
+	const nicID = 1
+
+	s := stack.New(stack.Options{
+		NetworkProtocols: []stack.NetworkProtocolFactory{fakeNetFactory},
+	})
+
+	e := channel.New(0, defaultMTU, "")
+	if err := s.CreateNIC(nicID, e); err != nil {
+		t.Fatalf("CreateNIC(%d, _): %s", nicID, err)
+	}
+
+	checkMTU := func(want uint32) {
+		t.Helper()
+
+		if got := s.NICInfo()[nicID].MTU; got != want {
+			t.Errorf("got s.NICInfo()[%d].MTU = %d, want = %d", nicID, got, want)
+		}
+	}
+
+	checkMTU(defaultMTU)
+
+	for _, mtu := range []uint32{0, defaultMTU + 1} {
+		if err := s.SetNICMTU(nicID, mtu); err != tcpip.ErrInvalidOptionValue {
+			t.Errorf("got s.SetNICMTU(%d, %d) = %v, want = %s", nicID, mtu, err, tcpip.ErrInvalidOptionValue)
+		}
+	}
+	checkMTU(defaultMTU)
+
+	const mtu = defaultMTU - 100
+	if err := s.SetNICMTU(nicID, mtu); err != nil {
+		t.Fatalf("s.SetNICMTU(%d, %d): %s", nicID, mtu, err)
+	}
+	checkMTU(mtu)
+
+	if err := s.SetNICMTU(nicID+1, mtu); err != tcpip.ErrUnknownNICID {
+		t.Errorf("got s.SetNICMTU(%d, %d) = %v, want = %s", nicID+1, mtu, err, tcpip.ErrUnknownNICID)
+	}
+}
+
 func TestRemoveUnknownNIC(t *testing.T) {
 	s := stack.New(stack.Options{
 		NetworkProtocols: []stack.NetworkProtocolFactory{fakeNetFactory},
@@ -1018,6 +1063,139 @@ func TestRoutes(t *testing.T) {
 	testNoRoute(t, s, 1, "\x03", "\x06")
 }
 
+func TestPolicyRouting(t *testing.T) {
+	const (
+		nicID1     = 1
+		nicID2     = 2
+		table      = 100
+		localAddr1 = tcpip.Address("\x01")
+		localAddr2 = tcpip.Address("\x02")
+		remoteAddr = tcpip.Address("\x05")
+	)
+
+	anySubnet, err := tcpip.NewSubnet("\x00", "\x00")
+	if err != nil {
+		t.Fatal(err)
+	}
+	localAddr2Subnet, err := tcpip.NewSubnet(localAddr2, "\xff")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// All rules also include the default ones, which look up the main table
+	// last.
+	tests := []struct {
+		name          string
+		rules         []stack.RoutingRule
+		nicID         tcpip.NICID
+		localAddr     tcpip.Address
+		opts          stack.RouteLookupOptions
+		wantLocalAddr tcpip.Address
+		wantErr       *tcpip.Error
+	}{
+		{
+			name:          "Default rules",
+			wantLocalAddr: localAddr1,
+		},
+		{
+			name:          "Matching mark",
+			rules:         []stack.RoutingRule{{Priority: 1, Mark: 1, MarkMask: 3, Table: table}},
+			opts:          stack.RouteLookupOptions{Mark: 5},
+			wantLocalAddr: localAddr2,
+		},
+		{
+			name:          "Non-matching mark",
+			rules:         []stack.RoutingRule{{Priority: 1, Mark: 1, MarkMask: 3, Table: table}},
+			opts:          stack.RouteLookupOptions{Mark: 2},
+			wantLocalAddr: localAddr1,
+		},
+		{
+			name:          "Inverted non-matching mark",
+			rules:         []stack.RoutingRule{{Priority: 1, Mark: 1, MarkMask: 3, Invert: true, Table: table}},
+			opts:          stack.RouteLookupOptions{Mark: 2},
+			wantLocalAddr: localAddr2,
+		},
+		{
+			name:          "Matching source",
+			rules:         []stack.RoutingRule{{Priority: 1, Source: localAddr2Subnet, Table: table}},
+			localAddr:     localAddr2,
+			wantLocalAddr: localAddr2,
+		},
+		{
+			name:      "Source without matching rule",
+			localAddr: localAddr2,
+			wantErr:   tcpip.ErrNoRoute,
+		},
+		{
+			name:          "Matching output NIC",
+			rules:         []stack.RoutingRule{{Priority: 1, OutputNIC: nicID2, Table: table}},
+			nicID:         nicID2,
+			wantLocalAddr: localAddr2,
+		},
+		{
+			name:    "Output NIC without matching rule",
+			nicID:   nicID2,
+			wantErr: tcpip.ErrNoRoute,
+		},
+		{
+			name:          "Input NIC does not match local packets",
+			rules:         []stack.RoutingRule{{Priority: 1, InputNIC: nicID1, Table: table}},
+			wantLocalAddr: localAddr1,
+		},
+		{
+			name:          "Suppressed default route",
+			rules:         []stack.RoutingRule{{Priority: 1, Table: table, SuppressPrefix: true, SuppressPrefixLength: 0}},
+			wantLocalAddr: localAddr1,
+		},
+		{
+			name:          "Rules evaluated by priority",
+			rules:         []stack.RoutingRule{{Priority: 2, Table: tcpip.MainRouteTable}, {Priority: 1, Table: table}},
+			wantLocalAddr: localAddr2,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			s := stack.New(stack.Options{
+				NetworkProtocols: []stack.NetworkProtocolFactory{fakeNetFactory},
+			})
+			for _, nic := range []struct {
+				id   tcpip.NICID
+				addr tcpip.Address
+			}{{nicID1, localAddr1}, {nicID2, localAddr2}} {
+				if err := s.CreateNIC(nic.id, channel.New(10, defaultMTU, "")); err != nil {
+					t.Fatalf("CreateNIC(%d, _): %s", nic.id, err)
+				}
+				if err := s.AddAddress(nic.id, fakeNetNumber, nic.addr); err != nil {
+					t.Fatalf("AddAddress(%d, %d, %s): %s", nic.id, fakeNetNumber, nic.addr, err)
+				}
+			}
+
+			// The main table routes through the first NIC and the other table
+			// through the second one.
+			s.SetRouteTable([]tcpip.Route{
+				{Destination: anySubnet, NIC: nicID1},
+				{Destination: anySubnet, NIC: nicID2, Table: table},
+			})
+			if test.rules != nil {
+				s.SetRoutingRules(fakeNetNumber, append(test.rules, s.GetRoutingRules(fakeNetNumber)...))
+			}
+
+			r, err := s.FindRouteWithOptions(test.nicID, test.localAddr, remoteAddr, fakeNetNumber, false /* multicastLoop */, test.opts)
+			if err != test.wantErr {
+				t.Fatalf("got FindRouteWithOptions(%d, %s, %s, %d, false, %#v) = (_, %s), want = (_, %s)", test.nicID, test.localAddr, remoteAddr, fakeNetNumber, test.opts, err, test.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			defer r.Release()
+			if got := r.LocalAddress; got != test.wantLocalAddr {
+				t.Errorf("got r.LocalAddress = %s, want = %s", got, test.wantLocalAddr)
+			}
+		})
+	}
+}
+
 func TestAddressRemoval(t *testing.T) {
 	const localAddrByte byte = 0x01
 	localAddr := tcpip.Address([]byte{localAddrByte})
@@ -2764,6 +2942,8 @@ func TestIPv6SourceAddressSelectionScopeAndSameAddress(t *testing.T) {
 		slaacPrefixForTempAddrBeforeNICAddrAdd tcpip.AddressWithPrefix
 		nicAddrs                               []tcpip.Address
 		slaacPrefixForTempAddrAfterNICAddrAdd  tcpip.AddressWithPrefix
+		preferPublicAddresses                  bool
+		addressLabels                          []stack.AddressLabel
 		remoteAddr                             tcpip.Address
 		expectedLocalAddr                      tcpip.Address
 	}{
@@ -2909,6 +3089,35 @@ func TestIPv6SourceAddressSelectionScopeAndSameAddress(t *testing.T) {
 			expectedLocalAddr:                     tempGlobalAddr1,
 		},
 
+		{
+			name:                                   "Public Global most preferred when reversed",
+			slaacPrefixForTempAddrBeforeNICAddrAdd: prefix1,
+			nicAddrs:                               []tcpip.Address{linkLocalAddr1, uniqueLocalAddr1},
+			preferPublicAddresses:                  true,
+			remoteAddr:                             globalAddr2,
+			expectedLocalAddr:                      stableGlobalAddr1.Address,
+		},
+
+		// Test Rule 6 of RFC 6724 section 5 with a modified policy table.
+		{
+			name:     "Unique Local most preferred with matching added label",
+			nicAddrs: []tcpip.Address{globalAddr1, uniqueLocalAddr1},
+			addressLabels: []stack.AddressLabel{
+				{Prefix: globalAddr3.WithPrefix().Subnet(), Label: 13},
+			},
+			remoteAddr:        globalAddr3,
+			expectedLocalAddr: uniqueLocalAddr1,
+		},
+		{
+			name:     "Added label of other NIC ignored",
+			nicAddrs: []tcpip.Address{globalAddr1, uniqueLocalAddr1},
+			addressLabels: []stack.AddressLabel{
+				{Prefix: globalAddr3.WithPrefix().Subnet(), NIC: nicID + 1, Label: 13},
+			},
+			remoteAddr:        globalAddr3,
+			expectedLocalAddr: globalAddr1,
+		},
+
 		// Test Rule 8 of RFC 6724 section 5 (use longest matching prefix).
 		{
 			name:              "Longest prefix matched most preferred (first address)",
@@ -2962,6 +3171,7 @@ func TestIPv6SourceAddressSelectionScopeAndSameAddress(t *testing.T) {
 						HandleRAs:                  true,
 						AutoGenGlobalAddresses:     true,
 						AutoGenTempGlobalAddresses: true,
+						PreferPublicAddresses:      test.preferPublicAddresses,
 					},
 					NDPDisp: &ndpDispatcher{},
 				})},
@@ -2971,6 +3181,17 @@ func TestIPv6SourceAddressSelectionScopeAndSameAddress(t *testing.T) {
 				t.Fatalf("CreateNIC(%d, _) = %s", nicID, err)
 			}
 
+			if len(test.addressLabels) != 0 {
+				labels, err := s.AddressLabels(ipv6.ProtocolNumber)
+				if err != nil {
+					t.Fatalf("s.AddressLabels(%d): %s", ipv6.ProtocolNumber, err)
+				}
+				labels = append(labels, test.addressLabels...)
+				if err := s.SetAddressLabels(ipv6.ProtocolNumber, labels); err != nil {
+					t.Fatalf("s.SetAddressLabels(%d, %#v): %s", ipv6.ProtocolNumber, labels, err)
+				}
+			}
+
 			if test.slaacPrefixForTempAddrBeforeNICAddrAdd != (tcpip.AddressWithPrefix{}) {
 				e.InjectInbound(header.IPv6ProtocolNumber, raBufWithPI(llAddr3, 0, test.slaacPrefixForTempAddrBeforeNICAddrAdd, true, true, lifetimeSeconds, lifetimeSeconds))
 			}
@@ -3012,6 +3233,81 @@ func TestIPv6SourceAddressSelectionScopeAndSameAddress(t *testing.T) {
 	}
 }
 
+func TestSetAddressLabels(t *testing.T) {
+	const nicID = 1
+	ipv6Subnet := tcpip.AddressWithPrefix{
+		Address:   "\xa0\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00",
+		PrefixLen: 16,
+	}.Subnet()
+	ipv4Subnet := tcpip.AddressWithPrefix{
+		Address:   "\x0a\x00\x00\x00",
+		PrefixLen: 8,
+	}.Subnet()
+
+	tests := []struct {
+		name    string
+		labels  []stack.AddressLabel
+		wantErr *tcpip.Error
+	}{
+		{
+			name: "Valid",
+			labels: []stack.AddressLabel{
+				{Prefix: ipv6Subnet, Label: 1},
+				{Prefix: ipv6Subnet, NIC: nicID, Label: 2},
+			},
+		},
+		{
+			name: "Duplicate",
+			labels: []stack.AddressLabel{
+				{Prefix: ipv6Subnet, Label: 1},
+				{Prefix: ipv6Subnet, Label: 2},
+			},
+			wantErr: tcpip.ErrDuplicateAddress,
+		},
+		{
+			name: "IPv4 prefix",
+			labels: []stack.AddressLabel{
+				{Prefix: ipv4Subnet, Label: 1},
+			},
+			wantErr: tcpip.ErrBadAddress,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			s := stack.New(stack.Options{
+				NetworkProtocols: []stack.NetworkProtocolFactory{ipv4.NewProtocol, ipv6.NewProtocol},
+			})
+
+			if _, err := s.AddressLabels(ipv4.ProtocolNumber); err != tcpip.ErrNotSupported {
+				t.Errorf("got s.AddressLabels(%d) = (_, %v), want = (_, %s)", ipv4.ProtocolNumber, err, tcpip.ErrNotSupported)
+			}
+
+			defaultLabels, err := s.AddressLabels(ipv6.ProtocolNumber)
+			if err != nil {
+				t.Fatalf("s.AddressLabels(%d): %s", ipv6.ProtocolNumber, err)
+			}
+
+			if err := s.SetAddressLabels(ipv6.ProtocolNumber, test.labels); err != test.wantErr {
+				t.Fatalf("got s.SetAddressLabels(%d, %#v) = %v, want = %v", ipv6.ProtocolNumber, test.labels, err, test.wantErr)
+			}
+
+			want := defaultLabels
+			if test.wantErr == nil {
+				// Entries restricted to a NIC are matched first.
+				want = []stack.AddressLabel{test.labels[1], test.labels[0]}
+			}
+			got, err := s.AddressLabels(ipv6.ProtocolNumber)
+			if err != nil {
+				t.Fatalf("s.AddressLabels(%d): %s", ipv6.ProtocolNumber, err)
+			}
+			if diff := cmp.Diff(want, got, cmp.AllowUnexported(tcpip.Subnet{})); diff != "" {
+				t.Errorf("address labels mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
 func TestAddRemoveIPv4BroadcastAddressOnNICEnableDisable(t *testing.T) {
 	const nicID = 1
 	broadcastAddr := tcpip.ProtocolAddress{
@@ -133,6 +133,21 @@ func (eps *transportEndpoints) findEndpointLocked(id TransportEndpointID) *endpo
 	return matchedEP
 }
 
+// findTProxyEndpointLocked returns the endpoint a packet diverted to the
+// transparent proxy tproxy is delivered to: the endpoint connected with the
+// given id if any, or the endpoint that most closely matches the proxy
+// address otherwise.
+//
+// Preconditions: eps.mu must be locked.
+func (eps *transportEndpoints) findTProxyEndpointLocked(id TransportEndpointID, tproxy TProxyInfo) *endpointsByNIC {
+	if ep, ok := eps.endpoints[id]; ok {
+		return ep
+	}
+	id.LocalAddress = tproxy.Addr
+	id.LocalPort = tproxy.Port
+	return eps.findEndpointLocked(id)
+}
+
 type endpointsByNIC struct {
 	mu        sync.RWMutex
 	endpoints map[tcpip.NICID]*multiPortEndpoint
@@ -155,6 +170,26 @@ func (epsByNIC *endpointsByNIC) transportEndpoints() []TransportEndpoint {
 func (epsByNIC *endpointsByNIC) handlePacket(id TransportEndpointID, pkt *PacketBuffer) {
 	epsByNIC.mu.RLock()
 
+	// If this is a broadcast or multicast datagram, deliver the datagram to all
+	// endpoints bound to the right device as well as to all endpoints not bound
+	// to any device.
+	if isInboundMulticastOrBroadcast(pkt, id.LocalAddress) {
+		bound, hasBound := epsByNIC.endpoints[pkt.NICID]
+		unbound, hasUnbound := epsByNIC.endpoints[0]
+		switch {
+		case hasBound && hasUnbound && pkt.NICID != 0:
+			bound.handlePacketAll(id, pkt.Clone())
+			unbound.handlePacketAll(id, pkt)
+		case hasBound:
+			bound.handlePacketAll(id, pkt)
+		case hasUnbound:
+			unbound.handlePacketAll(id, pkt)
+		}
+		epsByNIC.mu.RUnlock() // Don't use defer for performance reasons.
+		return
+	}
+
+	// Unicast packets prefer the endpoints bound to the receiving device.
 	mpep, ok := epsByNIC.endpoints[pkt.NICID]
 	if !ok {
 		if mpep, ok = epsByNIC.endpoints[0]; !ok {
@@ -162,14 +197,6 @@ func (epsByNIC *endpointsByNIC) handlePacket(id TransportEndpointID, pkt *Packet
 			return
 		}
 	}
-
-	// If this is a broadcast or multicast datagram, deliver the datagram to all
-	// endpoints bound to the right device.
-	if isInboundMulticastOrBroadcast(pkt, id.LocalAddress) {
-		mpep.handlePacketAll(id, pkt)
-		epsByNIC.mu.RUnlock() // Don't use defer for performance reasons.
-		return
-	}
 	// multiPortEndpoints are guaranteed to have at least one element.
 	transEP := selectEndpoint(id, mpep, epsByNIC.seed)
 	if queuedProtocol, mustQueue := mpep.demux.queuedProtocols[protocolIDs{mpep.netProto, mpep.transProto}]; mustQueue {
@@ -268,6 +295,10 @@ type queuedTransportProtocol interface {
 	QueuePacket(ep TransportEndpoint, id TransportEndpointID, pkt *PacketBuffer)
 }
 
+// maxTransportProtocolNumber is the largest transport protocol number that may
+// be carried by a network protocol, as IPv4 and IPv6 both use an 8-bit field.
+const maxTransportProtocolNumber = 255
+
 func newTransportDemuxer(stack *Stack) *transportDemuxer {
 	d := &transportDemuxer{
 		stack:           stack,
@@ -289,6 +320,21 @@ func newTransportDemuxer(stack *Stack) *transportDemuxer {
 		}
 	}
 
+	// Add every other transport protocol number so raw endpoints may be
+	// registered for protocols handled by network protocols (e.g. IGMP) and
+	// for protocols the stack knows nothing about.
+	for netProto := range stack.networkProtocols {
+		for proto := tcpip.TransportProtocolNumber(0); proto <= maxTransportProtocolNumber; proto++ {
+			protoIDs := protocolIDs{netProto, proto}
+			if _, ok := d.protocol[protoIDs]; ok {
+				continue
+			}
+			d.protocol[protoIDs] = &transportEndpoints{
+				endpoints: make(map[TransportEndpointID]*endpointsByNIC),
+			}
+		}
+	}
+
 	return d
 }
 
@@ -445,9 +491,19 @@ func (ep *multiPortEndpoint) unregisterEndpoint(t TransportEndpoint, flags ports
 
 	for i, endpoint := range ep.endpoints {
 		if endpoint == t {
-			copy(ep.endpoints[i:], ep.endpoints[i+1:])
-			ep.endpoints[len(ep.endpoints)-1] = nil
-			ep.endpoints = ep.endpoints[:len(ep.endpoints)-1]
+			last := len(ep.endpoints) - 1
+			if ep.flags.IntersectionRefs().ToFlags().Effective().MostRecent {
+				// SO_REUSEADDR groups deliver to the most recently
+				// bound endpoint, so they must stay in order.
+				copy(ep.endpoints[i:], ep.endpoints[i+1:])
+			} else {
+				// As in Linux, the last endpoint of SO_REUSEPORT
+				// groups takes the place of the removed one rather
+				// than shifting all the endpoints bound after it.
+				ep.endpoints[i] = ep.endpoints[last]
+			}
+			ep.endpoints[last] = nil
+			ep.endpoints = ep.endpoints[:last]
 
 			ep.flags.DropRef(flags.Bits() & ports.MultiBindFlagMask)
 			break
@@ -559,6 +615,18 @@ func (d *transportDemuxer) deliverPacket(protocol tcpip.TransportProtocolNumber,
 		return true
 	}
 
+	if pkt.TProxy.Port != 0 {
+		eps.mu.RLock()
+		ep := eps.findTProxyEndpointLocked(id, pkt.TProxy)
+		eps.mu.RUnlock()
+		// As in Linux, packets diverted to a transparent proxy without an
+		// endpoint are dropped.
+		if ep != nil {
+			ep.handlePacket(id, pkt)
+		}
+		return true
+	}
+
 	eps.mu.RLock()
 	ep := eps.findEndpointLocked(id)
 	eps.mu.RUnlock()
@@ -596,6 +664,28 @@ func (d *transportDemuxer) deliverRawPacket(protocol tcpip.TransportProtocolNumb
 	return foundRaw
 }
 
+// deliverRawControlPacket delivers the given control packet to all raw
+// endpoints registered for the offending packet's protocol. Returns true if
+// at least one raw endpoint received it.
+func (d *transportDemuxer) deliverRawControlPacket(net tcpip.NetworkProtocolNumber, trans tcpip.TransportProtocolNumber, typ ControlType, extra uint32, pkt *PacketBuffer) bool {
+	eps, ok := d.protocol[protocolIDs{net, trans}]
+	if !ok {
+		return false
+	}
+
+	// As in net/ipv4/icmp.c:icmp_socket_deliver, every raw socket of the
+	// protocol is told about the error.
+	foundRaw := false
+	eps.mu.RLock()
+	for _, rawEP := range eps.rawEndpoints {
+		rawEP.HandleControlPacket(typ, extra, pkt.Clone())
+		foundRaw = true
+	}
+	eps.mu.RUnlock()
+
+	return foundRaw
+}
+
 // deliverControlPacket attempts to deliver the given control packet. Returns
 // true if it found an endpoint, false otherwise.
 func (d *transportDemuxer) deliverControlPacket(n *NIC, net tcpip.NetworkProtocolNumber, trans tcpip.TransportProtocolNumber, typ ControlType, extra uint32, pkt *PacketBuffer, id TransportEndpointID) bool {
@@ -645,6 +735,56 @@ func (d *transportDemuxer) findTransportEndpoint(netProto tcpip.NetworkProtocolN
 	return ep
 }
 
+// findMigrationEndpoint returns the endpoint that a connection with the given
+// id, queued on the listening endpoint from, is migrated to when from stops
+// listening. It is selected with the same hash as incoming packets among the
+// other endpoints of the SO_REUSEPORT group of from bound to bindToDevice. It
+// returns nil if from isn't part of such a group with other endpoints.
+func (d *transportDemuxer) findMigrationEndpoint(netProto tcpip.NetworkProtocolNumber, transProto tcpip.TransportProtocolNumber, id TransportEndpointID, bindToDevice tcpip.NICID, from TransportEndpoint) TransportEndpoint {
+	eps, ok := d.protocol[protocolIDs{netProto, transProto}]
+	if !ok {
+		return nil
+	}
+
+	// Only look up listening endpoints: the connection itself is
+	// registered with id.
+	listenID := id
+	listenID.RemoteAddress = ""
+	listenID.RemotePort = 0
+
+	eps.mu.RLock()
+	epsByNIC := eps.findEndpointLocked(listenID)
+	if epsByNIC == nil {
+		eps.mu.RUnlock()
+		return nil
+	}
+
+	epsByNIC.mu.RLock()
+	eps.mu.RUnlock()
+	defer epsByNIC.mu.RUnlock()
+
+	mpep, ok := epsByNIC.endpoints[bindToDevice]
+	if !ok {
+		return nil
+	}
+
+	mpep.mu.RLock()
+	defer mpep.mu.RUnlock()
+	if !mpep.flags.IntersectionRefs().ToFlags().Effective().LoadBalanced {
+		return nil
+	}
+	others := make([]TransportEndpoint, 0, len(mpep.endpoints))
+	for _, ep := range mpep.endpoints {
+		if ep != from {
+			others = append(others, ep)
+		}
+	}
+	if len(others) == len(mpep.endpoints) || len(others) == 0 {
+		return nil
+	}
+	return selectEndpoint(id, &multiPortEndpoint{endpoints: others}, epsByNIC.seed)
+}
+
 // registerRawEndpoint registers the given endpoint with the dispatcher such
 // that packets of the appropriate protocol are delivered to it. A single
 // packet can be sent to one or more raw endpoints along with a non-raw
@@ -96,6 +96,10 @@ func newPayload() []byte {
 }
 
 func (c *testContext) sendV4Packet(payload []byte, h *headers, linkEpID tcpip.NICID) {
+	c.sendV4PacketTo(payload, h, testDstAddrV4, linkEpID)
+}
+
+func (c *testContext) sendV4PacketTo(payload []byte, h *headers, dstAddr tcpip.Address, linkEpID tcpip.NICID) {
 	buf := buffer.NewView(header.UDPMinimumSize + header.IPv4MinimumSize + len(payload))
 	payloadStart := len(buf) - len(payload)
 	copy(buf[payloadStart:], payload)
@@ -108,7 +112,7 @@ func (c *testContext) sendV4Packet(payload []byte, h *headers, linkEpID tcpip.NI
 		TTL:         65,
 		Protocol:    uint8(udp.ProtocolNumber),
 		SrcAddr:     testSrcAddrV4,
-		DstAddr:     testDstAddrV4,
+		DstAddr:     dstAddr,
 	})
 	ip.SetChecksum(^ip.CalculateChecksum())
 
@@ -121,7 +125,7 @@ func (c *testContext) sendV4Packet(payload []byte, h *headers, linkEpID tcpip.NI
 	})
 
 	// Calculate the UDP pseudo-header checksum.
-	xsum := header.PseudoHeaderChecksum(udp.ProtocolNumber, testSrcAddrV4, testDstAddrV4, uint16(len(u)))
+	xsum := header.PseudoHeaderChecksum(udp.ProtocolNumber, testSrcAddrV4, dstAddr, uint16(len(u)))
 
 	// Calculate the UDP checksum and set it.
 	xsum = header.Checksum(payload, xsum)
@@ -383,3 +387,85 @@ func TestBindToDeviceDistribution(t *testing.T) {
 		}
 	}
 }
+
+// TestBindToDeviceBroadcast checks that broadcast datagrams are delivered to
+// the endpoints bound to the receiving device as well as to the endpoints not
+// bound to any device.
+func TestBindToDeviceBroadcast(t *testing.T) {
+	for _, test := range []struct {
+		name   string
+		device tcpip.NICID
+		// wantRecv indicates whether the endpoint bound to device 1 and the
+		// unbound endpoint receive the datagram.
+		wantRecv [2]bool
+	}{
+		{name: "BoundDevice", device: 1, wantRecv: [2]bool{true, true}},
+		{name: "OtherDevice", device: 2, wantRecv: [2]bool{false, true}},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			c := newDualTestContextMultiNIC(t, defaultMTU, []tcpip.NICID{1, 2})
+
+			var eps [2]tcpip.Endpoint
+			for i, bindToDevice := range []tcpip.NICID{1, 0} {
+				var wq waiter.Queue
+				ep, err := c.s.NewEndpoint(udp.ProtocolNumber, ipv4.ProtocolNumber, &wq)
+				if err != nil {
+					t.Fatalf("NewEndpoint failed: %s", err)
+				}
+				defer ep.Close()
+				ep.SocketOptions().SetReusePort(true)
+				if err := ep.SocketOptions().SetBindToDevice(int32(bindToDevice)); err != nil {
+					t.Fatalf("SetBindToDevice(%d) on endpoint %d failed: %s", bindToDevice, i, err)
+				}
+				if err := ep.Bind(tcpip.FullAddress{Port: testDstPort}); err != nil {
+					t.Fatalf("ep.Bind(...) on endpoint %d failed: %s", i, err)
+				}
+				eps[i] = ep
+			}
+
+			c.sendV4PacketTo(newPayload(), &headers{srcPort: testSrcPort, dstPort: testDstPort}, header.IPv4Broadcast, test.device)
+
+			for i, ep := range eps {
+				_, err := ep.Read(ioutil.Discard, tcpip.ReadOptions{})
+				if got := err == nil; got != test.wantRecv[i] {
+					t.Errorf("got endpoint %d received = %t (err = %v), want = %t", i, got, err, test.wantRecv[i])
+				}
+			}
+		})
+	}
+}
+
+// TestBindToDeviceRouting checks that endpoints bound to a device only route
+// through that device.
+func TestBindToDeviceRouting(t *testing.T) {
+	for _, test := range []struct {
+		name         string
+		bindToDevice tcpip.NICID
+		nic          tcpip.NICID
+		wantErr      *tcpip.Error
+	}{
+		{name: "Unbound", bindToDevice: 0, nic: 0, wantErr: nil},
+		{name: "BoundToRouteDevice", bindToDevice: 1, nic: 0, wantErr: nil},
+		{name: "BoundToOtherDevice", bindToDevice: 2, nic: 0, wantErr: tcpip.ErrNoRoute},
+		{name: "ConflictingNIC", bindToDevice: 1, nic: 2, wantErr: tcpip.ErrNoRoute},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			// Both NICs exist but only NIC 1 has routes.
+			c := newDualTestContextMultiNIC(t, defaultMTU, []tcpip.NICID{1, 2})
+
+			var wq waiter.Queue
+			ep, err := c.s.NewEndpoint(udp.ProtocolNumber, ipv4.ProtocolNumber, &wq)
+			if err != nil {
+				t.Fatalf("NewEndpoint failed: %s", err)
+			}
+			defer ep.Close()
+			if err := ep.SocketOptions().SetBindToDevice(int32(test.bindToDevice)); err != nil {
+				t.Fatalf("SetBindToDevice(%d) failed: %s", test.bindToDevice, err)
+			}
+
+			if err := ep.Connect(tcpip.FullAddress{NIC: test.nic, Addr: testSrcAddrV4, Port: testSrcPort}); err != test.wantErr {
+				t.Errorf("got ep.Connect(...) = %v, want = %v", err, test.wantErr)
+			}
+		})
+	}
+}
@@ -556,6 +556,32 @@ type ControlMessages struct {
 	// PacketInfo holds interface and address data on an incoming packet.
 	PacketInfo IPPacketInfo
 
+	// HasIPv6PacketInfo indicates whether IPv6PacketInfo is set.
+	HasIPv6PacketInfo bool
+
+	// IPv6PacketInfo holds interface and address data on an incoming IPv6
+	// packet.
+	IPv6PacketInfo IPv6PacketInfo
+
+	// HasTTL indicates whether TTL is valid/set.
+	HasTTL bool
+
+	// TTL is the IPv4 time to live of the associated packet.
+	TTL uint8
+
+	// HasHopLimit indicates whether HopLimit is valid/set.
+	HasHopLimit bool
+
+	// HopLimit is the IPv6 hop limit of the associated packet.
+	HopLimit uint8
+
+	// HasFlowInfo indicates whether FlowInfo is valid/set.
+	HasFlowInfo bool
+
+	// FlowInfo holds the IPv6 traffic class and flow label of the associated
+	// packet.
+	FlowInfo uint32
+
 	// HasOriginalDestinationAddress indicates whether OriginalDstAddress is
 	// set.
 	HasOriginalDstAddress bool
@@ -566,6 +592,13 @@ type ControlMessages struct {
 
 	// SockErr is the dequeued socket error on recvmsg(MSG_ERRQUEUE).
 	SockErr *SockError
+
+	// HasGROSize indicates whether GROSize is valid/set.
+	HasGROSize bool
+
+	// GROSize is the size of the datagrams that were coalesced into the
+	// read data.
+	GROSize uint16
 }
 
 // PacketOwner is used to get UID and GID of the packet.
@@ -786,6 +819,16 @@ type WriteOptions struct {
 	// endpoint. If Atomic is false, then data fetched from the Payloader may be
 	// discarded if available endpoint buffer space is unsufficient.
 	Atomic bool
+
+	// FastOpen has the same semantics as Linux's MSG_FASTOPEN. If set on an
+	// unconnected stream endpoint, the endpoint connects to To and, when
+	// possible, carries the data in the SYN.
+	FastOpen bool
+
+	// GSOSize, if not zero, is the size of the datagrams that the payload
+	// of a write to a UDP endpoint is split into. It overrides
+	// UDPSegmentOption, like a UDP_SEGMENT control message does.
+	GSOSize uint16
 }
 
 // SockOptInt represents socket options which values have the int type.
@@ -857,6 +900,41 @@ const (
 	//
 	// NOTE: This option is currently only stubed out and is a no-op
 	TCPWindowClampOption
+
+	// TCPFastOpenOption is used by SetSockOptInt/GetSockOptInt to enable
+	// TCP Fast Open on a listening endpoint. The value is the maximum
+	// number of pending Fast Open connections; zero disables it.
+	TCPFastOpenOption
+
+	// TCPFastOpenConnectOption is used by SetSockOptInt/GetSockOptInt to
+	// specify that connect(2) should use TCP Fast Open and defer sending
+	// the SYN until data is written, as specified using the
+	// TCP_FASTOPEN_CONNECT option.
+	TCPFastOpenConnectOption
+
+	// UDPSegmentOption is used by SetSockOptInt/GetSockOptInt to specify
+	// the size of the datagrams that the payload of writes to a UDP
+	// endpoint is split into, as specified using the UDP_SEGMENT option.
+	// Zero disables segmentation.
+	UDPSegmentOption
+
+	// UDPGROOption is used by SetSockOptInt/GetSockOptInt to specify
+	// whether consecutive datagrams of the same size from the same sender
+	// may be coalesced when read from a UDP endpoint, as specified using
+	// the UDP_GRO option.
+	UDPGROOption
+
+	// MulticastRouterOption is used by SetSockOptInt/GetSockOptInt to
+	// specify whether a raw endpoint is the multicast routing endpoint of
+	// its network protocol, as specified using the MRT_INIT and MRT_DONE
+	// options.
+	MulticastRouterOption
+
+	// MulticastRouterAssertOption is used by SetSockOptInt/GetSockOptInt to
+	// specify whether a multicast routing endpoint is notified of packets
+	// arriving on unexpected interfaces, as specified using the MRT_ASSERT
+	// option.
+	MulticastRouterAssertOption
 )
 
 const (
@@ -985,6 +1063,98 @@ func (*TCPModerateReceiveBufferOption) isGettableTransportProtocolOption() {}
 
 func (*TCPModerateReceiveBufferOption) isSettableTransportProtocolOption() {}
 
+// TCPECNOption is used by stack.(*Stack).TransportProtocolOption to specify
+// whether TCP negotiates Explicit Congestion Notification, with the same
+// semantics as Linux's net.ipv4.tcp_ecn sysctl.
+//
+// See: https://tools.ietf.org/html/rfc3168 and
+// https://tools.ietf.org/html/draft-ietf-tcpm-accurate-ecn.
+type TCPECNOption int32
+
+func (*TCPECNOption) isGettableTransportProtocolOption() {}
+
+func (*TCPECNOption) isSettableTransportProtocolOption() {}
+
+const (
+	// TCPECNDisabled indicates ECN is neither requested nor accepted.
+	TCPECNDisabled TCPECNOption = iota
+
+	// TCPECNEnabled indicates ECN is requested by outgoing connections and
+	// accepted when requested by incoming connections.
+	TCPECNEnabled
+
+	// TCPECNPassive indicates ECN is accepted when requested by incoming
+	// connections but not requested by outgoing connections.
+	TCPECNPassive
+
+	// TCPAccECNEnabled indicates Accurate ECN is requested by outgoing
+	// connections and accepted when requested by incoming connections.
+	TCPAccECNEnabled
+
+	// TCPAccECNRequestECN indicates Accurate ECN is accepted when requested
+	// by incoming connections while outgoing connections request classic
+	// ECN.
+	TCPAccECNRequestECN
+
+	// TCPAccECNPassive indicates Accurate ECN is accepted when requested by
+	// incoming connections but no form of ECN is requested by outgoing
+	// connections.
+	TCPAccECNPassive
+)
+
+// TCPECNFallbackOption is used by stack.(*Stack).TransportProtocolOption to
+// specify whether retransmitted SYNs stop requesting ECN, as with Linux's
+// net.ipv4.tcp_ecn_fallback sysctl.
+type TCPECNFallbackOption bool
+
+func (*TCPECNFallbackOption) isGettableTransportProtocolOption() {}
+
+func (*TCPECNFallbackOption) isSettableTransportProtocolOption() {}
+
+// TCPSynCookiesOption is used by stack.(*Stack).TransportProtocolOption to
+// specify when listening endpoints answer SYNs with SYN cookies, with the same
+// semantics as Linux's net.ipv4.tcp_syncookies sysctl.
+type TCPSynCookiesOption int32
+
+func (*TCPSynCookiesOption) isGettableTransportProtocolOption() {}
+
+func (*TCPSynCookiesOption) isSettableTransportProtocolOption() {}
+
+const (
+	// TCPSynCookiesDisabled indicates SYN cookies are never sent: SYNs are
+	// dropped once too many connections are in SYN-RCVD.
+	TCPSynCookiesDisabled TCPSynCookiesOption = iota
+
+	// TCPSynCookiesOnOverflow indicates SYN cookies are sent once too many
+	// connections are in SYN-RCVD.
+	TCPSynCookiesOnOverflow
+
+	// TCPSynCookiesAlways indicates SYN cookies are sent in response to all
+	// SYNs, without keeping state for half open connections.
+	TCPSynCookiesAlways
+)
+
+// TCPAbortOnOverflowOption is used by stack.(*Stack).TransportProtocolOption
+// to specify whether the final ACK of a handshake is answered with a RST
+// rather than dropped when the accept queue of the listening endpoint is full,
+// as with Linux's net.ipv4.tcp_abort_on_overflow sysctl.
+type TCPAbortOnOverflowOption bool
+
+func (*TCPAbortOnOverflowOption) isGettableTransportProtocolOption() {}
+
+func (*TCPAbortOnOverflowOption) isSettableTransportProtocolOption() {}
+
+// TCPMigrateReqOption is used by stack.(*Stack).TransportProtocolOption to
+// specify whether the connections queued on a listening endpoint that closes or
+// shuts down are migrated to another endpoint of its SO_REUSEPORT group rather
+// than reset, as with Linux's net.ipv4.tcp_migrate_req sysctl. This lets
+// servers drain their listeners when restarting without dropping connections.
+type TCPMigrateReqOption bool
+
+func (*TCPMigrateReqOption) isGettableTransportProtocolOption() {}
+
+func (*TCPMigrateReqOption) isSettableTransportProtocolOption() {}
+
 // GettableSocketOption is a marker interface for socket options that may be
 // queried.
 type GettableSocketOption interface {
@@ -1071,6 +1241,17 @@ func (*TCPTimeWaitTimeoutOption) isGettableTransportProtocolOption() {}
 
 func (*TCPTimeWaitTimeoutOption) isSettableTransportProtocolOption() {}
 
+// TCPRestoreWindowOption is used by SetTransportProtocolOption to set the
+// maximum duration for which a connection may have been suspended by save and
+// restore, and still be resumed on restore. Connections that were suspended
+// for longer are reset, since their peers have probably given up on them. Zero
+// allows connections to be suspended for any duration.
+type TCPRestoreWindowOption time.Duration
+
+func (*TCPRestoreWindowOption) isGettableTransportProtocolOption() {}
+
+func (*TCPRestoreWindowOption) isSettableTransportProtocolOption() {}
+
 // TCPDeferAcceptOption is used by SetSockOpt/GetSockOpt to allow a
 // accept to return a completed connection only when there is data to be
 // read. This usually means the listening socket will drop the final ACK
@@ -1142,6 +1323,21 @@ func (*TCPSynRetriesOption) isGettableTransportProtocolOption() {}
 
 func (*TCPSynRetriesOption) isSettableTransportProtocolOption() {}
 
+// TCPMD5SigMaxKeyLen is the maximum length of a TCP MD5 signature key.
+const TCPMD5SigMaxKeyLen = 80
+
+// TCPMD5SigOption is used by SetSockOpt to install a TCP MD5 signature key
+// (RFC 2385) for the peers whose address matches Addr in its first PrefixLen
+// bits. An empty Key removes the key previously installed for Addr and
+// PrefixLen.
+type TCPMD5SigOption struct {
+	Addr      Address
+	PrefixLen int
+	Key       []byte
+}
+
+func (*TCPMD5SigOption) isSettableSocketOption() {}
+
 // MulticastInterfaceOption is used by SetSockOpt/GetSockOpt to specify a
 // default interface for multicast.
 type MulticastInterfaceOption struct {
@@ -1171,6 +1367,134 @@ type RemoveMembershipOption MembershipOption
 
 func (*RemoveMembershipOption) isSettableSocketOption() {}
 
+// SourceMembershipOption is used to identify a source-specific multicast
+// membership on an interface.
+type SourceMembershipOption struct {
+	NIC           NICID
+	InterfaceAddr Address
+	MulticastAddr Address
+	SourceAddr    Address
+}
+
+// AddSourceMembershipOption joins a multicast group on some interface,
+// receiving only packets from the given source. If the group is already
+// joined in source-specific mode, the source is added to the sources packets
+// are received from.
+type AddSourceMembershipOption SourceMembershipOption
+
+func (*AddSourceMembershipOption) isSettableSocketOption() {}
+
+// DropSourceMembershipOption stops receiving packets from the given source
+// for a multicast group joined in source-specific mode. The group is left
+// once packets are no longer received from any source.
+type DropSourceMembershipOption SourceMembershipOption
+
+func (*DropSourceMembershipOption) isSettableSocketOption() {}
+
+// BlockSourceOption stops receiving packets from the given source for a
+// multicast group joined with AddMembershipOption.
+type BlockSourceOption SourceMembershipOption
+
+func (*BlockSourceOption) isSettableSocketOption() {}
+
+// UnblockSourceOption resumes receiving packets from a source blocked with
+// BlockSourceOption.
+type UnblockSourceOption SourceMembershipOption
+
+func (*UnblockSourceOption) isSettableSocketOption() {}
+
+// AddMulticastInterfaceOption is used by SetSockOpt on a multicast routing
+// endpoint to add a virtual multicast interface, as specified using the
+// MRT_ADD_VIF and MRT6_ADD_MIF options.
+type AddMulticastInterfaceOption struct {
+	// Index is the index of the virtual interface, used by multicast routes.
+	Index uint16
+
+	// NIC is the NIC of the virtual interface. If zero, the NIC is the one
+	// InterfaceAddr is assigned to.
+	NIC NICID
+
+	// InterfaceAddr is the address of the NIC of the virtual interface when
+	// NIC is zero.
+	InterfaceAddr Address
+}
+
+func (*AddMulticastInterfaceOption) isSettableSocketOption() {}
+
+// RemoveMulticastInterfaceOption is used by SetSockOpt on a multicast routing
+// endpoint to remove a virtual multicast interface, as specified using the
+// MRT_DEL_VIF and MRT6_DEL_MIF options.
+type RemoveMulticastInterfaceOption struct {
+	Index uint16
+}
+
+func (*RemoveMulticastInterfaceOption) isSettableSocketOption() {}
+
+// AddMulticastRouteOption is used by SetSockOpt on a multicast routing
+// endpoint to add a multicast route, as specified using the MRT_ADD_MFC and
+// MRT6_ADD_MFC options.
+type AddMulticastRouteOption struct {
+	Source Address
+	Group  Address
+
+	// InputInterface is the index of the virtual interface packets using
+	// the route are expected to arrive on.
+	InputInterface uint16
+
+	// TTLs holds, for each virtual interface index, the TTL/HopLimit that
+	// packets must exceed to be forwarded through the virtual interface. A
+	// TTL of 0 or 255 indicates that packets are not forwarded through the
+	// virtual interface.
+	TTLs []uint8
+}
+
+func (*AddMulticastRouteOption) isSettableSocketOption() {}
+
+// RemoveMulticastRouteOption is used by SetSockOpt on a multicast routing
+// endpoint to remove a multicast route, as specified using the MRT_DEL_MFC
+// and MRT6_DEL_MFC options.
+type RemoveMulticastRouteOption struct {
+	Source Address
+	Group  Address
+}
+
+func (*RemoveMulticastRouteOption) isSettableSocketOption() {}
+
+// MulticastFilterMode is the filter mode of a multicast source filter, as
+// defined by RFC 3376 section 3.1 and RFC 3810 section 4.1.
+type MulticastFilterMode int
+
+const (
+	// MulticastFilterInclude indicates that only packets from the sources
+	// of the source filter are received.
+	MulticastFilterInclude MulticastFilterMode = iota
+
+	// MulticastFilterExclude indicates that packets from all sources but
+	// the sources of the source filter are received.
+	MulticastFilterExclude
+)
+
+// MulticastSourceFilter is the source filter of a multicast group membership.
+//
+// A membership joined without specifying sources is in exclude mode with no
+// sources.
+//
+// +stateify savable
+type MulticastSourceFilter struct {
+	Mode    MulticastFilterMode
+	Sources []Address
+}
+
+// Allows returns true if packets from src pass the filter.
+func (f *MulticastSourceFilter) Allows(src Address) bool {
+	for _, s := range f.Sources {
+		if s == src {
+			return f.Mode == MulticastFilterInclude
+		}
+	}
+	return f.Mode == MulticastFilterExclude
+}
+
 // SocketDetachFilterOption is used by SetSockOpt to detach a previously attached
 // classic BPF filter on a given endpoint.
 type SocketDetachFilterOption int
@@ -1234,6 +1558,17 @@ type IPPacketInfo struct {
 	DestinationAddr Address
 }
 
+// IPv6PacketInfo is the message structure for IPV6_PKTINFO.
+//
+// +stateify savable
+type IPv6PacketInfo struct {
+	// Addr is the destination address found in the IPv6 header.
+	Addr Address
+
+	// NIC is the ID of the NIC the packet was received on.
+	NIC NICID
+}
+
 // Route is a row in the routing table. It specifies through which NIC (and
 // gateway) sets of packets should be routed. A row is considered viable if the
 // masked target address matches the destination address in the row.
@@ -1246,6 +1581,9 @@ type Route struct {
 
 	// NIC is the id of the nic to be used if this row is viable.
 	NIC NICID
+
+	// Table is the ID of the routing table the row is in.
+	Table RouteTableID
 }
 
 // String implements the fmt.Stringer interface.
@@ -1256,9 +1594,24 @@ func (r Route) String() string {
 		fmt.Fprintf(&out, " via %s", r.Gateway)
 	}
 	fmt.Fprintf(&out, " nic %d", r.NIC)
+	if r.Table != MainRouteTable {
+		fmt.Fprintf(&out, " table %d", r.Table)
+	}
 	return out.String()
 }
 
+// RouteTableID is the ID of a routing table.
+type RouteTableID uint32
+
+// Well-known routing table IDs. They match the Linux ones, except for the main
+// table which is the zero value so that routes are in it unless specified
+// otherwise.
+const (
+	MainRouteTable    RouteTableID = 0
+	DefaultRouteTable RouteTableID = 253
+	LocalRouteTable   RouteTableID = 255
+)
+
 // Equal returns true if the given Route is equal to this Route.
 func (r Route) Equal(to Route) bool {
 	// NOTE: This relies on the fact that r.Destination == to.Destination
@@ -1474,6 +1827,10 @@ type ICMPv6PacketStats struct {
 	// messages counted.
 	MulticastListenerDone *StatCounter
 
+	// MulticastListenerReportV2 is the total number of Version 2 Multicast
+	// Listener Report messages counted.
+	MulticastListenerReportV2 *StatCounter
+
 	// LINT.ThenChange(network/ipv6/stats.go:multiCounterICMPv6PacketStats)
 }
 
@@ -1551,6 +1908,10 @@ type IGMPPacketStats struct {
 	// messages counted.
 	V2MembershipReport *StatCounter
 
+	// V3MembershipReport is the total number of Version 3 Membership Report
+	// messages counted.
+	V3MembershipReport *StatCounter
+
 	// LeaveGroup is the total number of Leave Group messages counted.
 	LeaveGroup *StatCounter
 
@@ -1757,6 +2118,11 @@ type TCPStats struct {
 	// in the handshake was dropped due to overflow.
 	ListenOverflowAckDrop *StatCounter
 
+	// ListenOverflowAckReset is the number of times the final ACK in the
+	// handshake was answered with a RST because the listen queue
+	// overflowed, as requested by TCPAbortOnOverflowOption.
+	ListenOverflowAckReset *StatCounter
+
 	// ListenOverflowCookieSent is the number of times a SYN cookie was sent.
 	ListenOverflowSynCookieSent *StatCounter
 
@@ -1768,6 +2134,16 @@ type TCPStats struct {
 	// was received.
 	ListenOverflowInvalidSynCookieRcvd *StatCounter
 
+	// MigrateReqSuccess is the number of connections that were migrated to
+	// another listening endpoint when their listening endpoint closed, as
+	// requested by TCPMigrateReqOption.
+	MigrateReqSuccess *StatCounter
+
+	// MigrateReqFailure is the number of connections that couldn't be
+	// migrated to another listening endpoint when their listening endpoint
+	// closed, and were reset instead.
+	MigrateReqFailure *StatCounter
+
 	// FailedConnectionAttempts is the number of calls to Connect or Listen
 	// (active and passive openings, respectively) that end in an error.
 	FailedConnectionAttempts *StatCounter
@@ -1803,6 +2179,10 @@ type TCPStats struct {
 	// recover from packet loss.
 	SACKRecovery *StatCounter
 
+	// ECNCongestionEvents is the number of times the congestion window was
+	// reduced in response to Explicit Congestion Notification feedback.
+	ECNCongestionEvents *StatCounter
+
 	// SlowStartRetransmits is the number of segments retransmitted in slow
 	// start.
 	SlowStartRetransmits *StatCounter
@@ -1816,6 +2196,10 @@ type TCPStats struct {
 
 	// ChecksumErrors is the number of segments dropped due to bad checksums.
 	ChecksumErrors *StatCounter
+
+	// MD5SignatureErrors is the number of segments dropped because their
+	// TCP MD5 signature was missing, unexpected or invalid.
+	MD5SignatureErrors *StatCounter
 }
 
 // UDPStats collects UDP-specific stats.
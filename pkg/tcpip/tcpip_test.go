@@ -344,3 +344,34 @@ func TestAddressMatchingPrefix(t *testing.T) {
 		}
 	}
 }
+
+func TestQueueZeroCopyCompletion(t *testing.T) {
+	const copied = 1
+	var so SocketOptions
+	so.QueueZeroCopyCompletion(0, 0, copied, 0)
+	so.QueueZeroCopyCompletion(1, 1, copied, 0)
+	// A gap in the range starts a new notification.
+	so.QueueZeroCopyCompletion(3, 4, copied, 0)
+	// As does a different code.
+	so.QueueZeroCopyCompletion(5, 5, 0, 0)
+
+	type completion struct {
+		Lo, Hi uint32
+		Code   uint8
+	}
+	var got []completion
+	for err := so.DequeueErr(); err != nil; err = so.DequeueErr() {
+		if err.ErrOrigin != SockExtErrorOriginZeroCopy {
+			t.Errorf("got err.ErrOrigin = %d, want = %d", err.ErrOrigin, SockExtErrorOriginZeroCopy)
+		}
+		got = append(got, completion{Lo: err.ErrInfo, Hi: err.ErrData, Code: err.ErrCode})
+	}
+	want := []completion{
+		{Lo: 0, Hi: 1, Code: copied},
+		{Lo: 3, Hi: 4, Code: copied},
+		{Lo: 5, Hi: 5, Code: 0},
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("completions mismatch (-want +got):\n%s", diff)
+	}
+}
@@ -15,15 +15,23 @@
 package integration_test
 
 import (
+	"context"
+	"fmt"
 	"testing"
+	"time"
 
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
 	"gvisor.dev/gvisor/pkg/tcpip"
 	"gvisor.dev/gvisor/pkg/tcpip/buffer"
+	"gvisor.dev/gvisor/pkg/tcpip/checker"
 	"gvisor.dev/gvisor/pkg/tcpip/header"
 	"gvisor.dev/gvisor/pkg/tcpip/link/channel"
 	"gvisor.dev/gvisor/pkg/tcpip/network/ipv4"
 	"gvisor.dev/gvisor/pkg/tcpip/network/ipv6"
 	"gvisor.dev/gvisor/pkg/tcpip/stack"
+	"gvisor.dev/gvisor/pkg/tcpip/transport/tcp"
+	"gvisor.dev/gvisor/pkg/waiter"
 )
 
 type inputIfNameMatcher struct {
@@ -334,3 +342,278 @@ func TestIPTablesStatsForInput(t *testing.T) {
 		})
 	}
 }
+
+// genSYNPacketV4 returns a TCP SYN from srcAddrV4:srcPort to dstAddrV4:dstPort.
+func genSYNPacketV4(srcPort, dstPort uint16) *stack.PacketBuffer {
+	return genSYNPacketV4To(dstAddrV4, srcPort, dstPort)
+}
+
+// genSYNPacketV4To returns a TCP SYN from srcAddrV4:srcPort to dst:dstPort.
+func genSYNPacketV4To(dst tcpip.Address, srcPort, dstPort uint16) *stack.PacketBuffer {
+	pktSize := header.IPv4MinimumSize + header.TCPMinimumSize
+	hdr := buffer.NewPrependable(pktSize)
+	tcpHdr := header.TCP(hdr.Prepend(header.TCPMinimumSize))
+	tcpHdr.Encode(&header.TCPFields{
+		SrcPort:    srcPort,
+		DstPort:    dstPort,
+		SeqNum:     1,
+		DataOffset: header.TCPMinimumSize,
+		Flags:      header.TCPFlagSyn,
+		WindowSize: 30000,
+	})
+	xsum := header.PseudoHeaderChecksum(header.TCPProtocolNumber, srcAddrV4, dst, header.TCPMinimumSize)
+	tcpHdr.SetChecksum(^tcpHdr.CalculateChecksum(xsum))
+	ip := header.IPv4(hdr.Prepend(header.IPv4MinimumSize))
+	ip.Encode(&header.IPv4Fields{
+		TotalLength: uint16(pktSize),
+		TTL:         64,
+		Protocol:    uint8(header.TCPProtocolNumber),
+		SrcAddr:     srcAddrV4,
+		DstAddr:     dst,
+	})
+	ip.SetChecksum(^ip.CalculateChecksum())
+	return stack.NewPacketBuffer(stack.PacketBufferOptions{Data: hdr.View().ToVectorisedView()})
+}
+
+// genConnTrackStack returns an IPv4 stack with connection tracking enabled.
+func genConnTrackStack(t *testing.T, settings stack.ConnTrackSettings) (*stack.Stack, *channel.Endpoint) {
+	t.Helper()
+	s := stack.New(stack.Options{
+		NetworkProtocols:   []stack.NetworkProtocolFactory{ipv4.NewProtocol},
+		TransportProtocols: []stack.TransportProtocolFactory{tcp.NewProtocol},
+	})
+	e := channel.New(1, header.IPv4MinimumMTU, linkAddr)
+	if err := s.CreateNIC(nicID, e); err != nil {
+		t.Fatalf("CreateNIC(%d, _): %s", nicID, err)
+	}
+	if err := s.AddAddress(nicID, header.IPv4ProtocolNumber, dstAddrV4); err != nil {
+		t.Fatalf("AddAddress(%d, %d, %s) = %s", nicID, header.IPv4ProtocolNumber, dstAddrV4, err)
+	}
+	s.SetRouteTable([]tcpip.Route{{Destination: header.IPv4EmptySubnet, NIC: nicID}})
+
+	ipt := s.IPTables()
+	if err := ipt.ConnTrack().SetSettings(settings); err != nil {
+		t.Fatalf("SetSettings(%#v): %s", settings, err)
+	}
+	// Replacing a table enables connection tracking.
+	if err := ipt.ReplaceTable(stack.NATID, ipt.GetTable(stack.NATID, false /* ipv6 */), false /* ipv6 */); err != nil {
+		t.Fatalf("ipt.ReplaceTable(%d, _, false): %s", stack.NATID, err)
+	}
+	return s, e
+}
+
+func TestConnTrackEntries(t *testing.T) {
+	const (
+		srcPort = 1000
+		dstPort = 80
+	)
+
+	settings := stack.DefaultConnTrackSettings()
+	settings.TCPSynSentTimeout = time.Hour
+	settings.TCPCloseTimeout = time.Hour
+	s, e := genConnTrackStack(t, settings)
+	e.InjectInbound(header.IPv4ProtocolNumber, genSYNPacketV4(srcPort, dstPort))
+
+	want := []stack.ConnTrackEntry{{
+		NetProto:   header.IPv4ProtocolNumber,
+		TransProto: header.TCPProtocolNumber,
+		Original: stack.ConnTrackTuple{
+			SrcAddr: srcAddrV4,
+			SrcPort: srcPort,
+			DstAddr: dstAddrV4,
+			DstPort: dstPort,
+		},
+		Reply: stack.ConnTrackTuple{
+			SrcAddr: dstAddrV4,
+			SrcPort: dstPort,
+			DstAddr: srcAddrV4,
+			DstPort: srcPort,
+		},
+	}}
+	ct := s.IPTables().ConnTrack()
+	entries := ct.Entries()
+	if diff := cmp.Diff(want, entries, cmpopts.IgnoreFields(stack.ConnTrackEntry{}, "ID", "TCPState", "SeenReply", "Timeout")); diff != "" {
+		t.Fatalf("entries mismatch (-want +got):\n%s", diff)
+	}
+	if timeout := entries[0].Timeout; timeout <= 0 || timeout > time.Hour {
+		t.Errorf("got entries[0].Timeout = %s, want in (0, %s]", timeout, time.Hour)
+	}
+	if got, want := ct.Stats(), (stack.ConnTrackStats{Connections: 1, Insert: 1}); got.Connections != want.Connections || got.Insert != want.Insert {
+		t.Errorf("got Stats() = %#v, want Connections and Insert of %#v", got, want)
+	}
+}
+
+func TestConnTrackMaxConnections(t *testing.T) {
+	settings := stack.DefaultConnTrackSettings()
+	settings.MaxConnections = 1
+	s, e := genConnTrackStack(t, settings)
+	e.InjectInbound(header.IPv4ProtocolNumber, genSYNPacketV4(1000, 80))
+	e.InjectInbound(header.IPv4ProtocolNumber, genSYNPacketV4(1001, 80))
+
+	ct := s.IPTables().ConnTrack()
+	if got := len(ct.Entries()); got != 1 {
+		t.Errorf("got len(Entries()) = %d, want = 1", got)
+	}
+	if got := ct.Stats().Drop; got != 1 {
+		t.Errorf("got Stats().Drop = %d, want = 1", got)
+	}
+	if got := s.Stats().IP.IPTablesPreroutingDropped.Value(); got != 1 {
+		t.Errorf("got IPTablesPreroutingDropped = %d, want = 1", got)
+	}
+
+	// Deleting the connection makes room for new ones.
+	if got := ct.DeleteEntries(func(*stack.ConnTrackEntry) bool { return true }); got != 1 {
+		t.Errorf("got DeleteEntries(_) = %d, want = 1", got)
+	}
+	e.InjectInbound(header.IPv4ProtocolNumber, genSYNPacketV4(1001, 80))
+	if got := len(ct.Entries()); got != 1 {
+		t.Errorf("got len(Entries()) = %d, want = 1", got)
+	}
+}
+
+func TestConnTrackDeleteEntries(t *testing.T) {
+	s, e := genConnTrackStack(t, stack.DefaultConnTrackSettings())
+	for port := uint16(1000); port < 1010; port++ {
+		e.InjectInbound(header.IPv4ProtocolNumber, genSYNPacketV4(port, 80))
+	}
+
+	ct := s.IPTables().ConnTrack()
+	if got := ct.DeleteEntries(func(entry *stack.ConnTrackEntry) bool { return entry.Original.SrcPort%2 == 0 }); got != 5 {
+		t.Errorf("got DeleteEntries(_) = %d, want = 5", got)
+	}
+	entries := ct.Entries()
+	if got := len(entries); got != 5 {
+		t.Fatalf("got len(Entries()) = %d, want = 5", got)
+	}
+	for _, entry := range entries {
+		if entry.Original.SrcPort%2 == 0 {
+			t.Errorf("found deleted entry %#v", entry)
+		}
+	}
+	if got := ct.Stats().Connections; got != 5 {
+		t.Errorf("got Stats().Connections = %d, want = 5", got)
+	}
+}
+
+func TestConnTrackResize(t *testing.T) {
+	settings := stack.DefaultConnTrackSettings()
+	settings.Buckets = 1
+	s, e := genConnTrackStack(t, settings)
+	for port := uint16(1000); port < 1010; port++ {
+		e.InjectInbound(header.IPv4ProtocolNumber, genSYNPacketV4(port, 80))
+	}
+
+	ct := s.IPTables().ConnTrack()
+	settings.Buckets = 7
+	if err := ct.SetSettings(settings); err != nil {
+		t.Fatalf("SetSettings(%#v): %s", settings, err)
+	}
+	if got := len(ct.Entries()); got != 10 {
+		t.Errorf("got len(Entries()) = %d, want = 10", got)
+	}
+
+	// Packets of existing connections are still found after rehashing.
+	found := ct.Stats().Found
+	e.InjectInbound(header.IPv4ProtocolNumber, genSYNPacketV4(1000, 80))
+	if got := ct.Stats().Found; got <= found {
+		t.Errorf("got Stats().Found = %d, want > %d", got, found)
+	}
+	if got := len(ct.Entries()); got != 10 {
+		t.Errorf("got len(Entries()) = %d, want = 10", got)
+	}
+}
+
+func TestTProxy(t *testing.T) {
+	const (
+		srcPort   = 1000
+		dstPort   = 80
+		proxyPort = 8080
+
+		// origDstAddrV4 is not assigned to the stack.
+		origDstAddrV4 = tcpip.Address("\x0a\x00\x00\x03")
+	)
+
+	for _, transparent := range []bool{true, false} {
+		t.Run(fmt.Sprintf("Transparent=%t", transparent), func(t *testing.T) {
+			s, e := genConnTrackStack(t, stack.DefaultConnTrackSettings())
+
+			// Divert all TCP packets to the proxy.
+			table := stack.Table{
+				Rules: []stack.Rule{
+					{
+						Filter: stack.IPHeaderFilter{
+							Protocol:      header.TCPProtocolNumber,
+							CheckProtocol: true,
+						},
+						Target: &stack.TProxyTarget{
+							Port:            proxyPort,
+							NetworkProtocol: header.IPv4ProtocolNumber,
+						},
+					},
+					{Target: &stack.AcceptTarget{NetworkProtocol: header.IPv4ProtocolNumber}},
+					{Target: &stack.AcceptTarget{NetworkProtocol: header.IPv4ProtocolNumber}},
+					{Target: &stack.ErrorTarget{NetworkProtocol: header.IPv4ProtocolNumber}},
+				},
+				BuiltinChains: [stack.NumHooks]int{
+					stack.Prerouting:  0,
+					stack.Input:       stack.HookUnset,
+					stack.Forward:     stack.HookUnset,
+					stack.Output:      2,
+					stack.Postrouting: stack.HookUnset,
+				},
+				Underflows: [stack.NumHooks]int{
+					stack.Prerouting:  1,
+					stack.Input:       stack.HookUnset,
+					stack.Forward:     stack.HookUnset,
+					stack.Output:      2,
+					stack.Postrouting: stack.HookUnset,
+				},
+			}
+			if err := s.IPTables().ReplaceTable(stack.MangleID, table, false /* ipv6 */); err != nil {
+				t.Fatalf("ReplaceTable(%d, _, false): %s", stack.MangleID, err)
+			}
+
+			var wq waiter.Queue
+			ep, err := s.NewEndpoint(tcp.ProtocolNumber, ipv4.ProtocolNumber, &wq)
+			if err != nil {
+				t.Fatalf("NewEndpoint(%d, %d, _): %s", tcp.ProtocolNumber, ipv4.ProtocolNumber, err)
+			}
+			defer ep.Close()
+			ep.SocketOptions().SetTransparent(transparent)
+			bindAddr := tcpip.FullAddress{Addr: dstAddrV4, Port: proxyPort}
+			if err := ep.Bind(bindAddr); err != nil {
+				t.Fatalf("Bind(%#v): %s", bindAddr, err)
+			}
+			if err := ep.Listen(1); err != nil {
+				t.Fatalf("Listen(1): %s", err)
+			}
+
+			e.InjectInbound(header.IPv4ProtocolNumber, genSYNPacketV4To(origDstAddrV4, srcPort, dstPort))
+
+			ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+			defer cancel()
+			p, ok := e.ReadContext(ctx)
+			if !transparent {
+				// Only transparent endpoints accept diverted packets.
+				if ok {
+					t.Fatalf("got unexpected packet = %#v", p)
+				}
+				return
+			}
+			if !ok {
+				t.Fatal("timed out waiting for SYN-ACK")
+			}
+
+			// The proxy answers on behalf of the original destination.
+			checker.IPv4(t, stack.PayloadSince(p.Pkt.NetworkHeader()),
+				checker.SrcAddr(origDstAddrV4),
+				checker.DstAddr(srcAddrV4),
+				checker.TCP(
+					checker.SrcPort(dstPort),
+					checker.DstPort(srcPort),
+					checker.TCPFlags(header.TCPFlagSyn|header.TCPFlagAck),
+				),
+			)
+		})
+	}
+}
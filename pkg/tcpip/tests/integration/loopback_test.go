@@ -28,6 +28,7 @@ import (
 	"gvisor.dev/gvisor/pkg/tcpip/network/ipv4"
 	"gvisor.dev/gvisor/pkg/tcpip/network/ipv6"
 	"gvisor.dev/gvisor/pkg/tcpip/stack"
+	"gvisor.dev/gvisor/pkg/tcpip/transport/raw"
 	"gvisor.dev/gvisor/pkg/tcpip/transport/tcp"
 	"gvisor.dev/gvisor/pkg/tcpip/transport/udp"
 	"gvisor.dev/gvisor/pkg/waiter"
@@ -64,6 +65,8 @@ func (*ndpDispatcher) OnRecursiveDNSServerOption(tcpip.NICID, []tcpip.Address, t
 
 func (*ndpDispatcher) OnDNSSearchListOption(tcpip.NICID, []string, time.Duration) {}
 
+func (*ndpDispatcher) OnPref64Option(tcpip.NICID, tcpip.Subnet, time.Duration) {}
+
 func (*ndpDispatcher) OnDHCPv6Configuration(tcpip.NICID, ipv6.DHCPv6ConfigurationFromNDPRA) {}
 
 // TestInitialLoopbackAddresses tests that the loopback interface does not
@@ -494,3 +497,123 @@ func TestLoopbackAcceptAllInSubnetTCP(t *testing.T) {
 		})
 	}
 }
+
+// TestLoopbackRawUnknownProtocol tests that raw endpoints may be used for
+// transport protocols the stack does not implement, and that they report the
+// requested ancillary data.
+func TestLoopbackRawUnknownProtocol(t *testing.T) {
+	const (
+		nicID = 1
+
+		// unknownProtocol is a transport protocol that netstack doesn't
+		// implement.
+		unknownProtocol = 253
+	)
+
+	tests := []struct {
+		name      string
+		netProto  tcpip.NetworkProtocolNumber
+		addr      tcpip.Address
+		headerLen int
+		setOpts   func(*tcpip.SocketOptions)
+		wantCM    tcpip.ControlMessages
+	}{
+		{
+			name:      "IPv4",
+			netProto:  ipv4.ProtocolNumber,
+			addr:      ipv4Addr.Address,
+			headerLen: header.IPv4MinimumSize,
+			setOpts: func(ops *tcpip.SocketOptions) {
+				ops.SetReceiveTTL(true)
+				ops.SetReceivePacketInfo(true)
+			},
+			wantCM: tcpip.ControlMessages{
+				HasTTL:          true,
+				TTL:             ipv4.DefaultTTL,
+				HasIPPacketInfo: true,
+				PacketInfo: tcpip.IPPacketInfo{
+					NIC:             nicID,
+					LocalAddr:       ipv4Addr.Address,
+					DestinationAddr: ipv4Addr.Address,
+				},
+			},
+		},
+		{
+			name:     "IPv6",
+			netProto: ipv6.ProtocolNumber,
+			addr:     ipv6Addr.Address,
+			// Raw IPv6 endpoints don't return the IP header.
+			headerLen: 0,
+			setOpts: func(ops *tcpip.SocketOptions) {
+				ops.SetReceiveHopLimit(true)
+				ops.SetReceiveIPv6PacketInfo(true)
+			},
+			wantCM: tcpip.ControlMessages{
+				HasHopLimit:       true,
+				HopLimit:          ipv6.DefaultTTL,
+				HasIPv6PacketInfo: true,
+				IPv6PacketInfo: tcpip.IPv6PacketInfo{
+					Addr: ipv6Addr.Address,
+					NIC:  nicID,
+				},
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			s := stack.New(stack.Options{
+				NetworkProtocols: []stack.NetworkProtocolFactory{ipv4.NewProtocol, ipv6.NewProtocol},
+				RawFactory:       raw.EndpointFactory{},
+			})
+			if err := s.CreateNIC(nicID, loopback.New()); err != nil {
+				t.Fatalf("s.CreateNIC(%d, _): %s", nicID, err)
+			}
+			if err := s.AddAddress(nicID, test.netProto, test.addr); err != nil {
+				t.Fatalf("s.AddAddress(%d, %d, %s): %s", nicID, test.netProto, test.addr, err)
+			}
+			s.SetRouteTable([]tcpip.Route{
+				{
+					Destination: header.IPv4EmptySubnet,
+					NIC:         nicID,
+				},
+				{
+					Destination: header.IPv6EmptySubnet,
+					NIC:         nicID,
+				},
+			})
+
+			var wq waiter.Queue
+			ep, err := s.NewRawEndpoint(unknownProtocol, test.netProto, &wq, true /* associated */)
+			if err != nil {
+				t.Fatalf("s.NewRawEndpoint(%d, %d, _, true): %s", unknownProtocol, test.netProto, err)
+			}
+			defer ep.Close()
+			test.setOpts(ep.SocketOptions())
+
+			data := []byte{1, 2, 3, 4}
+			var r bytes.Reader
+			r.Reset(data)
+			to := tcpip.FullAddress{Addr: test.addr}
+			if n, err := ep.Write(&r, tcpip.WriteOptions{To: &to}); err != nil {
+				t.Fatalf("ep.Write(_, {To: %#v}): %s", to, err)
+			} else if n != int64(len(data)) {
+				t.Fatalf("got ep.Write(_, {To: %#v}) = %d, want = %d", to, n, len(data))
+			}
+
+			var buf bytes.Buffer
+			res, err := ep.Read(&buf, tcpip.ReadOptions{})
+			if err != nil {
+				t.Fatalf("ep.Read(_, {}): %s", err)
+			}
+			if diff := cmp.Diff(data, buf.Bytes()[test.headerLen:]); diff != "" {
+				t.Errorf("received data mismatch (-want +got):\n%s", diff)
+			}
+			res.ControlMessages.HasTimestamp = false
+			res.ControlMessages.Timestamp = 0
+			if diff := cmp.Diff(test.wantCM, res.ControlMessages); diff != "" {
+				t.Errorf("control messages mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
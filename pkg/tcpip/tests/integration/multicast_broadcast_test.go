@@ -30,6 +30,7 @@ import (
 	"gvisor.dev/gvisor/pkg/tcpip/network/ipv6"
 	"gvisor.dev/gvisor/pkg/tcpip/stack"
 	"gvisor.dev/gvisor/pkg/tcpip/transport/icmp"
+	"gvisor.dev/gvisor/pkg/tcpip/transport/raw"
 	"gvisor.dev/gvisor/pkg/tcpip/transport/udp"
 	"gvisor.dev/gvisor/pkg/waiter"
 )
@@ -769,3 +770,119 @@ func TestUDPAddRemoveMembershipSocketOption(t *testing.T) {
 		})
 	}
 }
+
+// TestMulticastRouting tests that a multicast routing endpoint is notified of
+// multicast packets without a route and that the routes it installs are used
+// to forward them.
+func TestMulticastRouting(t *testing.T) {
+	const (
+		inputNICID  = 1
+		outputNICID = 2
+		inputVIF    = 0
+		outputVIF   = 1
+
+		// igmpMsgNoCache is IGMPMSG_NOCACHE from Linux's
+		// uapi/linux/mroute.h.
+		igmpMsgNoCache = 1
+	)
+
+	group := tcpip.Address(net.ParseIP("225.0.0.1").To4())
+	data := []byte{1, 2, 3, 4}
+
+	s := stack.New(stack.Options{
+		NetworkProtocols:   []stack.NetworkProtocolFactory{ipv4.NewProtocol},
+		TransportProtocols: []stack.TransportProtocolFactory{udp.NewProtocol},
+		RawFactory:         raw.EndpointFactory{},
+	})
+	inputEP := channel.New(1, defaultMTU, "")
+	if err := s.CreateNIC(inputNICID, inputEP); err != nil {
+		t.Fatalf("CreateNIC(%d, _): %s", inputNICID, err)
+	}
+	outputEP := channel.New(1, defaultMTU, "")
+	if err := s.CreateNIC(outputNICID, outputEP); err != nil {
+		t.Fatalf("CreateNIC(%d, _): %s", outputNICID, err)
+	}
+	for nicID, addr := range map[tcpip.NICID]tcpip.AddressWithPrefix{
+		inputNICID:  ipv4Addr,
+		outputNICID: {Address: tcpip.Address(net.ParseIP("10.0.1.1").To4()), PrefixLen: 24},
+	} {
+		protoAddr := tcpip.ProtocolAddress{Protocol: ipv4.ProtocolNumber, AddressWithPrefix: addr}
+		if err := s.AddProtocolAddress(nicID, protoAddr); err != nil {
+			t.Fatalf("AddProtocolAddress(%d, %#v): %s", nicID, protoAddr, err)
+		}
+	}
+
+	var wq waiter.Queue
+	ep, err := s.NewRawEndpoint(header.IGMPProtocolNumber, ipv4.ProtocolNumber, &wq, true /* associated */)
+	if err != nil {
+		t.Fatalf("NewRawEndpoint(%d, %d, _, true): %s", header.IGMPProtocolNumber, ipv4.ProtocolNumber, err)
+	}
+	defer ep.Close()
+
+	if err := ep.SetSockOptInt(tcpip.MulticastRouterOption, 1); err != nil {
+		t.Fatalf("SetSockOptInt(MulticastRouterOption, 1): %s", err)
+	}
+	for _, opt := range []tcpip.AddMulticastInterfaceOption{
+		{Index: inputVIF, NIC: inputNICID},
+		{Index: outputVIF, NIC: outputNICID},
+	} {
+		if err := ep.SetSockOpt(&opt); err != nil {
+			t.Fatalf("SetSockOpt(&%#v): %s", opt, err)
+		}
+	}
+
+	rxIPv4UDP(inputEP, remoteIPv4Addr, group, data)
+	if pkt, ok := outputEP.Read(); ok {
+		t.Fatalf("unexpectedly forwarded packet without a route: %#v", pkt)
+	}
+
+	var buf bytes.Buffer
+	if _, err := ep.Read(&buf, tcpip.ReadOptions{}); err != nil {
+		t.Fatalf("ep.Read(_, {}): %s", err)
+	}
+	msg := buf.Bytes()
+	if got, want := len(msg), header.IPv4MinimumSize+header.IGMPMinimumSize; got != want {
+		t.Fatalf("got len(msg) = %d, want = %d", got, want)
+	}
+	if got := msg[8]; got != igmpMsgNoCache {
+		t.Errorf("got message type = %d, want = %d", got, igmpMsgNoCache)
+	}
+	if got := msg[10]; got != inputVIF {
+		t.Errorf("got message VIF = %d, want = %d", got, inputVIF)
+	}
+	if got := header.IPv4(msg).SourceAddress(); got != remoteIPv4Addr {
+		t.Errorf("got message source = %s, want = %s", got, remoteIPv4Addr)
+	}
+	if got := header.IPv4(msg).DestinationAddress(); got != group {
+		t.Errorf("got message group = %s, want = %s", got, group)
+	}
+
+	// Installing the route forwards the pending packet.
+	opt := tcpip.AddMulticastRouteOption{
+		Source:         remoteIPv4Addr,
+		Group:          group,
+		InputInterface: inputVIF,
+		TTLs:           []uint8{inputVIF: 0, outputVIF: 1},
+	}
+	if err := ep.SetSockOpt(&opt); err != nil {
+		t.Fatalf("SetSockOpt(&%#v): %s", opt, err)
+	}
+	pkt, ok := outputEP.Read()
+	if !ok {
+		t.Fatal("expected pending packet to be forwarded")
+	}
+	checker.IPv4(t, stack.PayloadSince(pkt.Pkt.NetworkHeader()),
+		checker.SrcAddr(remoteIPv4Addr),
+		checker.DstAddr(group),
+		checker.TTL(ttl-1),
+	)
+
+	// Packets matching the route are forwarded directly.
+	rxIPv4UDP(inputEP, remoteIPv4Addr, group, data)
+	if _, ok := outputEP.Read(); !ok {
+		t.Fatal("expected packet to be forwarded")
+	}
+	if _, err := ep.Read(&buf, tcpip.ReadOptions{}); err != tcpip.ErrWouldBlock {
+		t.Fatalf("got ep.Read(_, {}) = %s, want = %s", err, tcpip.ErrWouldBlock)
+	}
+}
@@ -297,6 +297,10 @@ func (e *endpoint) write(p tcpip.Payloader, opts tcpip.WriteOptions) (int64, *tc
 
 			nicID = e.BindNICID
 		}
+		nicID, err := e.deviceNICID(nicID)
+		if err != nil {
+			return 0, err
+		}
 
 		dst, netProto, err := e.checkV4MappedLocked(*to)
 		if err != nil {
@@ -304,7 +308,7 @@ func (e *endpoint) write(p tcpip.Payloader, opts tcpip.WriteOptions) (int64, *tc
 		}
 
 		// Find the endpoint.
-		r, err := e.stack.FindRoute(nicID, e.BindAddr, dst.Addr, netProto, false /* multicastLoop */)
+		r, err := e.stack.FindRouteWithOptions(nicID, e.BindAddr, dst.Addr, netProto, false /* multicastLoop */, stack.RouteLookupOptions{Mark: e.ops.GetMark()})
 		if err != nil {
 			return 0, err
 		}
@@ -470,6 +474,19 @@ func (*endpoint) Disconnect() *tcpip.Error {
 	return tcpip.ErrNotSupported
 }
 
+// deviceNICID returns the NIC to route through when nicID (0 for any) is
+// requested, taking the device the endpoint is bound to into account.
+func (e *endpoint) deviceNICID(nicID tcpip.NICID) (tcpip.NICID, *tcpip.Error) {
+	bindToDevice := tcpip.NICID(e.ops.GetBindToDevice())
+	if bindToDevice == 0 {
+		return nicID, nil
+	}
+	if nicID != 0 && nicID != bindToDevice {
+		return 0, tcpip.ErrNoRoute
+	}
+	return bindToDevice, nil
+}
+
 // Connect connects the endpoint to its peer. Specifying a NIC is optional.
 func (e *endpoint) Connect(addr tcpip.FullAddress) *tcpip.Error {
 	e.mu.Lock()
@@ -494,13 +511,18 @@ func (e *endpoint) Connect(addr tcpip.FullAddress) *tcpip.Error {
 		return tcpip.ErrInvalidEndpointState
 	}
 
+	nicID, err := e.deviceNICID(nicID)
+	if err != nil {
+		return err
+	}
+
 	addr, netProto, err := e.checkV4MappedLocked(addr)
 	if err != nil {
 		return err
 	}
 
 	// Find a route to the desired destination.
-	r, err := e.stack.FindRoute(nicID, e.BindAddr, addr.Addr, netProto, false /* multicastLoop */)
+	r, err := e.stack.FindRouteWithOptions(nicID, e.BindAddr, addr.Addr, netProto, false /* multicastLoop */, stack.RouteLookupOptions{Mark: e.ops.GetMark()})
 	if err != nil {
 		return err
 	}
@@ -734,6 +756,11 @@ func (e *endpoint) HandlePacket(id stack.TransportEndpointID, pkt *stack.PacketB
 		}
 	}
 
+	// A socket bound to a device only receives packets from that device.
+	if bindToDevice := tcpip.NICID(e.ops.GetBindToDevice()); bindToDevice != 0 && bindToDevice != pkt.NICID {
+		return
+	}
+
 	e.rcvMu.Lock()
 
 	// Drop the packet if our buffer is currently full.
@@ -76,7 +76,7 @@ func (e *endpoint) Resume(s *stack.Stack) {
 
 	var err *tcpip.Error
 	if e.state == stateConnected {
-		e.route, err = e.stack.FindRoute(e.RegisterNICID, e.BindAddr, e.ID.RemoteAddress, e.NetProto, false /* multicastLoop */)
+		e.route, err = e.stack.FindRouteWithOptions(e.RegisterNICID, e.BindAddr, e.ID.RemoteAddress, e.NetProto, false /* multicastLoop */, stack.RouteLookupOptions{Mark: e.ops.GetMark()})
 		if err != nil {
 			panic(err)
 		}
@@ -47,6 +47,14 @@ type rawPacket struct {
 	timestampNS int64
 	// senderAddr is the network address of the sender.
 	senderAddr tcpip.FullAddress
+	// packetInfo holds the interface and destination address of the packet.
+	packetInfo tcpip.IPPacketInfo
+	// tos stores either the TOS or the traffic class of the packet.
+	tos uint8
+	// ttl stores either the TTL or the hop limit of the packet.
+	ttl uint8
+	// flowInfo stores the traffic class and flow label of IPv6 packets.
+	flowInfo uint32
 }
 
 // endpoint is the raw socket implementation of tcpip.Endpoint. It is legal to
@@ -54,7 +62,8 @@ type rawPacket struct {
 //
 // Lock order:
 //   endpoint.mu
-//     endpoint.rcvMu
+//     endpoint.mrouter.mu
+//       endpoint.rcvMu
 //
 // +stateify savable
 type endpoint struct {
@@ -92,6 +101,15 @@ type endpoint struct {
 
 	// ops is used to get socket level options.
 	ops tcpip.SocketOptions
+
+	// mrouter holds the multicast routing state of the endpoint. It is not
+	// saved, in the same way as the routes of the stack.
+	mrouter multicastRouter `state:"nosave"`
+
+	// lastError is the last error reported by an ICMP message. It is
+	// protected by lastErrorMu.
+	lastErrorMu sync.Mutex   `state:"nosave"`
+	lastError   *tcpip.Error `state:".(string)"`
 }
 
 // NewEndpoint returns a raw  endpoint for the given protocols.
@@ -161,6 +179,12 @@ func (e *endpoint) Close() {
 
 	e.stack.UnregisterRawTransportEndpoint(e.RegisterNICID, e.NetProto, e.TransProto, e)
 
+	e.mrouter.mu.Lock()
+	if e.mrouter.enabled {
+		e.mrouter.disableLocked(e.stack, e.NetProto)
+	}
+	e.mrouter.mu.Unlock()
+
 	e.rcvMu.Lock()
 	defer e.rcvMu.Unlock()
 
@@ -215,11 +239,8 @@ func (e *endpoint) Read(dst io.Writer, opts tcpip.ReadOptions) (tcpip.ReadResult
 	e.rcvMu.Unlock()
 
 	res := tcpip.ReadResult{
-		Total: pkt.data.Size(),
-		ControlMessages: tcpip.ControlMessages{
-			HasTimestamp: true,
-			Timestamp:    pkt.timestampNS,
-		},
+		Total:           pkt.data.Size(),
+		ControlMessages: e.controlMessages(pkt),
 	}
 	if opts.NeedRemoteAddr {
 		res.RemoteAddr = pkt.senderAddr
@@ -233,6 +254,53 @@ func (e *endpoint) Read(dst io.Writer, opts tcpip.ReadOptions) (tcpip.ReadResult
 	return res, nil
 }
 
+// controlMessages returns the control messages enabled on the endpoint for
+// pkt.
+func (e *endpoint) controlMessages(pkt *rawPacket) tcpip.ControlMessages {
+	cm := tcpip.ControlMessages{
+		HasTimestamp: true,
+		Timestamp:    pkt.timestampNS,
+	}
+	switch e.NetProto {
+	case header.IPv4ProtocolNumber:
+		if e.ops.GetReceiveTOS() {
+			cm.HasTOS = true
+			cm.TOS = pkt.tos
+		}
+		if e.ops.GetReceiveTTL() {
+			cm.HasTTL = true
+			cm.TTL = pkt.ttl
+		}
+		if e.ops.GetReceivePacketInfo() {
+			cm.HasIPPacketInfo = true
+			cm.PacketInfo = pkt.packetInfo
+		}
+	case header.IPv6ProtocolNumber:
+		if e.ops.GetReceiveTClass() {
+			cm.HasTClass = true
+			// Although TClass is an 8-bit value it's read in the CMsg as a
+			// uint32.
+			cm.TClass = uint32(pkt.tos)
+		}
+		if e.ops.GetReceiveHopLimit() {
+			cm.HasHopLimit = true
+			cm.HopLimit = pkt.ttl
+		}
+		if e.ops.GetReceiveFlowInfo() {
+			cm.HasFlowInfo = true
+			cm.FlowInfo = pkt.flowInfo
+		}
+		if e.ops.GetReceiveIPv6PacketInfo() {
+			cm.HasIPv6PacketInfo = true
+			cm.IPv6PacketInfo = tcpip.IPv6PacketInfo{
+				Addr: pkt.packetInfo.DestinationAddr,
+				NIC:  pkt.packetInfo.NIC,
+			}
+		}
+	}
+	return cm
+}
+
 // Write implements tcpip.Endpoint.Write.
 func (e *endpoint) Write(p tcpip.Payloader, opts tcpip.WriteOptions) (int64, *tcpip.Error) {
 	// We can create, but not write to, unassociated IPv6 endpoints.
@@ -285,18 +353,34 @@ func (e *endpoint) write(p tcpip.Payloader, opts tcpip.WriteOptions) (int64, *tc
 		return 0, tcpip.ErrBadBuffer
 	}
 
-	// If this is an unassociated socket and callee provided a nonzero
+	// If the IP header is included and callee provided a nonzero
 	// destination address, route using that address.
 	if e.ops.GetHeaderIncluded() {
-		ip := header.IPv4(payloadBytes)
-		if !ip.IsValid(len(payloadBytes)) {
-			return 0, tcpip.ErrInvalidOptionValue
+		var dstAddr tcpip.Address
+		switch e.NetProto {
+		case header.IPv4ProtocolNumber:
+			ip := header.IPv4(payloadBytes)
+			if !ip.IsValid(len(payloadBytes)) {
+				return 0, tcpip.ErrInvalidOptionValue
+			}
+			dstAddr = ip.DestinationAddress()
+			if dstAddr == header.IPv4Any {
+				dstAddr = ""
+			}
+		case header.IPv6ProtocolNumber:
+			ip := header.IPv6(payloadBytes)
+			if !ip.IsValid(len(payloadBytes)) {
+				return 0, tcpip.ErrInvalidOptionValue
+			}
+			dstAddr = ip.DestinationAddress()
+			if dstAddr == header.IPv6Any {
+				dstAddr = ""
+			}
 		}
-		dstAddr := ip.DestinationAddress()
 		// Update dstAddr with the address in the IP header, unless
 		// opts.To is set (e.g. if sendto specifies a specific
 		// address).
-		if dstAddr != tcpip.Address([]byte{0, 0, 0, 0}) && opts.To == nil {
+		if dstAddr != "" && opts.To == nil {
 			opts.To = &tcpip.FullAddress{
 				NIC:  0,       // NIC is unset.
 				Addr: dstAddr, // The address from the payload.
@@ -323,10 +407,14 @@ func (e *endpoint) write(p tcpip.Payloader, opts tcpip.WriteOptions) (int64, *tc
 	if e.bound && nic != 0 && nic != e.BindNICID {
 		return 0, tcpip.ErrNoRoute
 	}
+	nic, err := e.deviceNICID(nic)
+	if err != nil {
+		return 0, err
+	}
 
 	// Find the route to the destination. If BindAddress is 0,
 	// FindRoute will choose an appropriate source address.
-	route, err := e.stack.FindRoute(nic, e.BindAddr, opts.To.Addr, e.NetProto, false)
+	route, err := e.stack.FindRouteWithOptions(nic, e.BindAddr, opts.To.Addr, e.NetProto, false, stack.RouteLookupOptions{Mark: e.ops.GetMark()})
 	if err != nil {
 		return 0, err
 	}
@@ -369,6 +457,19 @@ func (*endpoint) Disconnect() *tcpip.Error {
 	return tcpip.ErrNotSupported
 }
 
+// deviceNICID returns the NIC to route through when nic (0 for any) is
+// requested, taking the device the endpoint is bound to into account.
+func (e *endpoint) deviceNICID(nic tcpip.NICID) (tcpip.NICID, *tcpip.Error) {
+	bindToDevice := tcpip.NICID(e.ops.GetBindToDevice())
+	if bindToDevice == 0 {
+		return nic, nil
+	}
+	if nic != 0 && nic != bindToDevice {
+		return 0, tcpip.ErrNoRoute
+	}
+	return bindToDevice, nil
+}
+
 // Connect implements tcpip.Endpoint.Connect.
 func (e *endpoint) Connect(addr tcpip.FullAddress) *tcpip.Error {
 	// Raw sockets do not support connecting to a IPv4 address on a IPv6 endpoint.
@@ -398,9 +499,13 @@ func (e *endpoint) Connect(addr tcpip.FullAddress) *tcpip.Error {
 			return tcpip.ErrInvalidEndpointState
 		}
 	}
+	nic, err := e.deviceNICID(nic)
+	if err != nil {
+		return err
+	}
 
 	// Find a route to the destination.
-	route, err := e.stack.FindRoute(nic, tcpip.Address(""), addr.Addr, e.NetProto, false)
+	route, err := e.stack.FindRouteWithOptions(nic, tcpip.Address(""), addr.Addr, e.NetProto, false, stack.RouteLookupOptions{Mark: e.ops.GetMark()})
 	if err != nil {
 		return err
 	}
@@ -499,10 +604,22 @@ func (e *endpoint) Readiness(mask waiter.EventMask) waiter.EventMask {
 
 // SetSockOpt implements tcpip.Endpoint.SetSockOpt.
 func (e *endpoint) SetSockOpt(opt tcpip.SettableSocketOption) *tcpip.Error {
-	switch opt.(type) {
+	switch v := opt.(type) {
 	case *tcpip.SocketDetachFilterOption:
 		return nil
 
+	case *tcpip.AddMulticastInterfaceOption:
+		return e.addMulticastInterface(v)
+
+	case *tcpip.RemoveMulticastInterfaceOption:
+		return e.removeMulticastInterface(v)
+
+	case *tcpip.AddMulticastRouteOption:
+		return e.addMulticastRoute(v)
+
+	case *tcpip.RemoveMulticastRouteOption:
+		return e.removeMulticastRoute(v)
+
 	default:
 		return tcpip.ErrUnknownProtocolOption
 	}
@@ -547,6 +664,12 @@ func (e *endpoint) SetSockOptInt(opt tcpip.SockOptInt, v int) *tcpip.Error {
 		e.rcvMu.Unlock()
 		return nil
 
+	case tcpip.MulticastRouterOption:
+		return e.setMulticastRouter(v != 0)
+
+	case tcpip.MulticastRouterAssertOption:
+		return e.setMulticastRouterAssert(v != 0)
+
 	default:
 		return tcpip.ErrUnknownProtocolOption
 	}
@@ -582,6 +705,18 @@ func (e *endpoint) GetSockOptInt(opt tcpip.SockOptInt) (int, *tcpip.Error) {
 		e.rcvMu.Unlock()
 		return v, nil
 
+	case tcpip.MulticastRouterOption:
+		if enabled, _ := e.multicastRouterState(); enabled {
+			return 1, nil
+		}
+		return 0, nil
+
+	case tcpip.MulticastRouterAssertOption:
+		if _, assert := e.multicastRouterState(); assert {
+			return 1, nil
+		}
+		return 0, nil
+
 	default:
 		return -1, tcpip.ErrUnknownProtocolOption
 	}
@@ -616,7 +751,15 @@ func (e *endpoint) HandlePacket(pkt *stack.PacketBuffer) {
 		return
 	}
 
-	remoteAddr := pkt.Network().SourceAddress()
+	// A socket bound to a device only receives packets from that device.
+	if bindToDevice := tcpip.NICID(e.ops.GetBindToDevice()); bindToDevice != 0 && bindToDevice != pkt.NICID {
+		e.rcvMu.Unlock()
+		e.mu.RUnlock()
+		return
+	}
+
+	netHeader := pkt.Network()
+	remoteAddr := netHeader.SourceAddress()
 
 	if e.bound {
 		// If bound to a NIC, only accept data for that NIC.
@@ -649,6 +792,23 @@ func (e *endpoint) HandlePacket(pkt *stack.PacketBuffer) {
 			NIC:  pkt.NICID,
 			Addr: remoteAddr,
 		},
+		packetInfo: tcpip.IPPacketInfo{
+			NIC:             pkt.NICID,
+			LocalAddr:       netHeader.DestinationAddress(),
+			DestinationAddr: netHeader.DestinationAddress(),
+		},
+	}
+	switch e.NetProto {
+	case header.IPv4ProtocolNumber:
+		h := header.IPv4(pkt.NetworkHeader().View())
+		packet.tos, _ = h.TOS()
+		packet.ttl = h.TTL()
+	case header.IPv6ProtocolNumber:
+		h := header.IPv6(pkt.NetworkHeader().View())
+		var flowLabel uint32
+		packet.tos, flowLabel = h.TOS()
+		packet.flowInfo = uint32(packet.tos)<<20 | flowLabel
+		packet.ttl = h.HopLimit()
 	}
 
 	// Raw IPv4 endpoints return the IP header, but IPv6 endpoints do not.
@@ -702,9 +862,84 @@ func (e *endpoint) Stats() tcpip.EndpointStats {
 // Wait implements stack.TransportEndpoint.Wait.
 func (*endpoint) Wait() {}
 
+// HandleControlPacket implements stack.RawTransportEndpoint.HandleControlPacket.
+//
+// As in net/ipv4/raw.c:raw_err, errors are only reported to connected
+// endpoints unless extended error reporting is enabled.
+func (e *endpoint) HandleControlPacket(typ stack.ControlType, extra uint32, pkt *stack.PacketBuffer) {
+	if !e.associated {
+		return
+	}
+
+	e.mu.RLock()
+	connected := e.connected
+	e.mu.RUnlock()
+
+	recvErr := e.ops.GetRecvError()
+	if !connected && !recvErr {
+		return
+	}
+
+	err, errType, errCode := controlError(pkt.NetworkProtocolNumber, typ)
+	if recvErr {
+		e.ops.QueueErr(&tcpip.SockError{
+			Err:       err,
+			ErrOrigin: header.ICMPOriginFromNetProto(pkt.NetworkProtocolNumber),
+			ErrType:   errType,
+			ErrCode:   errCode,
+			ErrInfo:   extra,
+			// Linux passes the offending packet from its transport header.
+			Payload: pkt.Data.ToView(),
+			Dst: tcpip.FullAddress{
+				NIC: pkt.NICID,
+			},
+			NetProto: pkt.NetworkProtocolNumber,
+		})
+	}
+
+	e.lastErrorMu.Lock()
+	e.lastError = err
+	e.lastErrorMu.Unlock()
+
+	e.waiterQueue.Notify(waiter.EventErr)
+}
+
+// controlError returns the error, ICMP type and ICMP code corresponding to
+// the control message typ for the network protocol netProto.
+func controlError(netProto tcpip.NetworkProtocolNumber, typ stack.ControlType) (*tcpip.Error, byte, byte) {
+	if netProto == header.IPv6ProtocolNumber {
+		switch typ {
+		case stack.ControlPacketTooBig:
+			return tcpip.ErrMessageTooLong, byte(header.ICMPv6PacketTooBig), 0
+		case stack.ControlPortUnreachable:
+			return tcpip.ErrConnectionRefused, byte(header.ICMPv6DstUnreachable), byte(header.ICMPv6PortUnreachable)
+		case stack.ControlAddressUnreachable:
+			return tcpip.ErrNoRoute, byte(header.ICMPv6DstUnreachable), byte(header.ICMPv6AddressUnreachable)
+		default:
+			return tcpip.ErrNoRoute, byte(header.ICMPv6DstUnreachable), byte(header.ICMPv6NetworkUnreachable)
+		}
+	}
+
+	switch typ {
+	case stack.ControlPacketTooBig:
+		return tcpip.ErrMessageTooLong, byte(header.ICMPv4DstUnreachable), byte(header.ICMPv4FragmentationNeeded)
+	case stack.ControlPortUnreachable:
+		return tcpip.ErrConnectionRefused, byte(header.ICMPv4DstUnreachable), byte(header.ICMPv4PortUnreachable)
+	case stack.ControlAddressUnreachable:
+		return tcpip.ErrNoRoute, byte(header.ICMPv4DstUnreachable), byte(header.ICMPv4HostUnreachable)
+	default:
+		return tcpip.ErrNoRoute, byte(header.ICMPv4DstUnreachable), byte(header.ICMPv4NetUnreachable)
+	}
+}
+
 // LastError implements tcpip.Endpoint.LastError.
-func (*endpoint) LastError() *tcpip.Error {
-	return nil
+func (e *endpoint) LastError() *tcpip.Error {
+	e.lastErrorMu.Lock()
+	defer e.lastErrorMu.Unlock()
+
+	err := e.lastError
+	e.lastError = nil
+	return err
 }
 
 // SocketOptions implements tcpip.Endpoint.SocketOptions.
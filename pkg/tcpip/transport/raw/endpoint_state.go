@@ -36,6 +36,24 @@ func (p *rawPacket) loadData(data buffer.VectorisedView) {
 	p.data = data
 }
 
+// saveLastError is invoked by stateify.
+func (e *endpoint) saveLastError() string {
+	if e.lastError == nil {
+		return ""
+	}
+
+	return e.lastError.String()
+}
+
+// loadLastError is invoked by stateify.
+func (e *endpoint) loadLastError(s string) {
+	if s == "" {
+		return
+	}
+
+	e.lastError = tcpip.StringToError(s)
+}
+
 // beforeSave is invoked by stateify.
 func (e *endpoint) beforeSave() {
 	// Stop incoming packets from being handled (and mutate endpoint state).
@@ -79,7 +97,7 @@ func (e *endpoint) Resume(s *stack.Stack) {
 		// to a route instead of the route by value, we pass the empty address
 		// directly. Obviously this was always wrong since we should provide the
 		// remote address we were connected to, to properly restore the route.
-		e.route, err = e.stack.FindRoute(e.RegisterNICID, e.BindAddr, "", e.NetProto, false)
+		e.route, err = e.stack.FindRouteWithOptions(e.RegisterNICID, e.BindAddr, "", e.NetProto, false, stack.RouteLookupOptions{Mark: e.ops.GetMark()})
 		if err != nil {
 			panic(err)
 		}
@@ -0,0 +1,452 @@
+// Copyright 2021 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package raw
+
+import (
+	"encoding/binary"
+	"time"
+
+	"gvisor.dev/gvisor/pkg/sync"
+	"gvisor.dev/gvisor/pkg/tcpip"
+	"gvisor.dev/gvisor/pkg/tcpip/buffer"
+	"gvisor.dev/gvisor/pkg/tcpip/header"
+	"gvisor.dev/gvisor/pkg/tcpip/stack"
+	"gvisor.dev/gvisor/pkg/waiter"
+)
+
+const (
+	// maxMulticastInterfaces is the maximum number of virtual multicast
+	// interfaces, as per Linux's MAXVIFS and MAXMIFS.
+	maxMulticastInterfaces = 32
+
+	// multicastAssertInterval is the minimum interval between two
+	// notifications of packets arriving on an unexpected interface for the
+	// same route, as per Linux's MFC_ASSERT_THRESH.
+	multicastAssertInterval = 3 * time.Second
+
+	// Message types of the notifications sent to the multicast routing
+	// endpoint, as per Linux's IGMPMSG_* and MRT6MSG_* values.
+	multicastMessageNoCache  = 1
+	multicastMessageWrongVIF = 2
+
+	// ipv4MulticastMessageSize is the size of Linux's struct igmpmsg,
+	// followed by an IGMP header.
+	ipv4MulticastMessageSize = header.IPv4MinimumSize + header.IGMPMinimumSize
+
+	// ipv6MulticastMessageSize is the size of Linux's struct mrt6msg.
+	ipv6MulticastMessageSize = 8 + 2*header.IPv6AddressSize
+)
+
+// multicastRouter holds the state of a raw endpoint that is the multicast
+// routing endpoint of its network protocol, i.e. the endpoint multicast
+// routing daemons use to manage the multicast routes of the stack.
+//
+// The routes are expressed in terms of virtual interfaces, which map to NICs.
+// Routes are reinstalled in the stack when virtual interfaces change.
+type multicastRouter struct {
+	mu sync.Mutex
+
+	// enabled is true if the endpoint is the multicast routing endpoint.
+	enabled bool
+
+	// assert is true if the endpoint is notified of packets arriving on
+	// unexpected interfaces.
+	assert bool
+
+	// interfaces maps the virtual interface indexes to NICs.
+	interfaces map[uint16]tcpip.NICID
+
+	// routes holds the routes added by the endpoint.
+	routes map[stack.UnicastSourceAndMulticastDestination]*multicastRouterRoute
+}
+
+// multicastRouterRoute is a route added to a multicastRouter.
+type multicastRouterRoute struct {
+	option tcpip.AddMulticastRouteOption
+
+	// lastAssert is the monotonic time of the last notification of a packet
+	// arriving on an unexpected interface for the route.
+	lastAssert int64
+}
+
+// multicastRouterAddresses returns the addresses of the given multicast route.
+func multicastRouterAddresses(source, group tcpip.Address) stack.UnicastSourceAndMulticastDestination {
+	return stack.UnicastSourceAndMulticastDestination{
+		Source:      source,
+		Destination: group,
+	}
+}
+
+// setMulticastRouter enables or disables the endpoint as the multicast routing
+// endpoint of its network protocol.
+func (e *endpoint) setMulticastRouter(enable bool) *tcpip.Error {
+	m := &e.mrouter
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if !enable {
+		if !m.enabled {
+			return tcpip.ErrNotPermitted
+		}
+		m.disableLocked(e.stack, e.NetProto)
+		return nil
+	}
+
+	if m.enabled {
+		return tcpip.ErrPortInUse
+	}
+
+	// As per Linux, only raw IGMP and ICMPv6 endpoints may be multicast
+	// routing endpoints.
+	switch {
+	case !e.associated:
+		return tcpip.ErrNotSupported
+	case e.NetProto == header.IPv4ProtocolNumber && e.TransProto != header.IGMPProtocolNumber:
+		return tcpip.ErrNotSupported
+	case e.NetProto == header.IPv6ProtocolNumber && e.TransProto != header.ICMPv6ProtocolNumber:
+		return tcpip.ErrNotSupported
+	}
+
+	alreadyEnabled, err := e.stack.EnableMulticastForwardingForProtocol(e.NetProto, e)
+	if err != nil {
+		return err
+	}
+	if alreadyEnabled {
+		return tcpip.ErrPortInUse
+	}
+
+	m.enabled = true
+	m.interfaces = make(map[uint16]tcpip.NICID)
+	m.routes = make(map[stack.UnicastSourceAndMulticastDestination]*multicastRouterRoute)
+	return nil
+}
+
+// disableLocked disables multicast forwarding for the network protocol of the
+// multicast routing endpoint.
+//
+// Precondition: m.mu must be locked and m.enabled must be true.
+func (m *multicastRouter) disableLocked(s *stack.Stack, netProto tcpip.NetworkProtocolNumber) {
+	_ = s.DisableMulticastForwardingForProtocol(netProto)
+	m.enabled = false
+	m.assert = false
+	m.interfaces = nil
+	m.routes = nil
+}
+
+// setMulticastRouterAssert sets whether the multicast routing endpoint is
+// notified of packets arriving on unexpected interfaces.
+func (e *endpoint) setMulticastRouterAssert(assert bool) *tcpip.Error {
+	m := &e.mrouter
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if !m.enabled {
+		return tcpip.ErrNotPermitted
+	}
+	m.assert = assert
+	return nil
+}
+
+// multicastRouterState returns whether the endpoint is the multicast routing
+// endpoint and whether it is notified of packets arriving on unexpected
+// interfaces.
+func (e *endpoint) multicastRouterState() (enabled bool, assert bool) {
+	m := &e.mrouter
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.enabled, m.assert
+}
+
+// addMulticastInterface adds a virtual interface to the multicast routing
+// endpoint.
+func (e *endpoint) addMulticastInterface(opt *tcpip.AddMulticastInterfaceOption) *tcpip.Error {
+	m := &e.mrouter
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if !m.enabled {
+		return tcpip.ErrNotPermitted
+	}
+	if opt.Index >= maxMulticastInterfaces {
+		return tcpip.ErrInvalidOptionValue
+	}
+	if _, ok := m.interfaces[opt.Index]; ok {
+		return tcpip.ErrPortInUse
+	}
+
+	nicID := opt.NIC
+	if nicID == 0 {
+		if nicID = e.stack.CheckLocalAddress(0, e.NetProto, opt.InterfaceAddr); nicID == 0 {
+			return tcpip.ErrBadLocalAddress
+		}
+	}
+	info, ok := e.stack.NICInfo()[nicID]
+	if !ok {
+		return tcpip.ErrUnknownNICID
+	}
+	// Packets forwarded through a loopback interface would be handled while
+	// the route is being installed, so they are not supported.
+	if info.Flags.Loopback {
+		return tcpip.ErrNotSupported
+	}
+	for _, id := range m.interfaces {
+		if id == nicID {
+			return tcpip.ErrPortInUse
+		}
+	}
+
+	m.interfaces[opt.Index] = nicID
+	m.reinstallRoutesLocked(e.stack, e.NetProto)
+	return nil
+}
+
+// removeMulticastInterface removes a virtual interface from the multicast
+// routing endpoint.
+func (e *endpoint) removeMulticastInterface(opt *tcpip.RemoveMulticastInterfaceOption) *tcpip.Error {
+	m := &e.mrouter
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if !m.enabled {
+		return tcpip.ErrNotPermitted
+	}
+	if _, ok := m.interfaces[opt.Index]; !ok {
+		return tcpip.ErrBadLocalAddress
+	}
+
+	delete(m.interfaces, opt.Index)
+	m.reinstallRoutesLocked(e.stack, e.NetProto)
+	return nil
+}
+
+// addMulticastRoute adds a route to the multicast routing endpoint and
+// installs it in the stack.
+func (e *endpoint) addMulticastRoute(opt *tcpip.AddMulticastRouteOption) *tcpip.Error {
+	m := &e.mrouter
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if !m.enabled {
+		return tcpip.ErrNotPermitted
+	}
+	if len(opt.TTLs) > maxMulticastInterfaces {
+		return tcpip.ErrInvalidOptionValue
+	}
+	if _, ok := m.interfaces[opt.InputInterface]; !ok {
+		return tcpip.ErrInvalidOptionValue
+	}
+
+	addresses := multicastRouterAddresses(opt.Source, opt.Group)
+	route, _ := m.stackRouteLocked(opt)
+	if err := e.stack.AddMulticastRoute(e.NetProto, addresses, route); err != nil {
+		return err
+	}
+
+	r := &multicastRouterRoute{option: *opt}
+	r.option.TTLs = append([]uint8(nil), opt.TTLs...)
+	m.routes[addresses] = r
+	return nil
+}
+
+// removeMulticastRoute removes a route from the multicast routing endpoint and
+// the stack.
+func (e *endpoint) removeMulticastRoute(opt *tcpip.RemoveMulticastRouteOption) *tcpip.Error {
+	m := &e.mrouter
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if !m.enabled {
+		return tcpip.ErrNotPermitted
+	}
+
+	addresses := multicastRouterAddresses(opt.Source, opt.Group)
+	if _, ok := m.routes[addresses]; !ok {
+		return tcpip.ErrNoSuchFile
+	}
+	delete(m.routes, addresses)
+
+	// The route is not installed in the stack if its input interface was
+	// removed.
+	if err := e.stack.RemoveMulticastRoute(e.NetProto, addresses); err != nil && err != tcpip.ErrNoSuchFile {
+		return err
+	}
+	return nil
+}
+
+// stackRouteLocked returns the stack route for opt. It returns false if the
+// input interface of the route does not exist.
+//
+// Precondition: m.mu must be locked.
+func (m *multicastRouter) stackRouteLocked(opt *tcpip.AddMulticastRouteOption) (stack.MulticastRoute, bool) {
+	input, ok := m.interfaces[opt.InputInterface]
+	if !ok {
+		return stack.MulticastRoute{}, false
+	}
+
+	route := stack.MulticastRoute{ExpectedInputInterface: input}
+	for i, ttl := range opt.TTLs {
+		if ttl == 0 || ttl == 255 {
+			continue
+		}
+		nicID, ok := m.interfaces[uint16(i)]
+		if !ok {
+			continue
+		}
+		// Packets are forwarded if their TTL/HopLimit exceeds the threshold.
+		route.OutgoingInterfaces = append(route.OutgoingInterfaces, stack.MulticastRouteOutgoingInterface{
+			ID:     nicID,
+			MinTTL: ttl + 1,
+		})
+	}
+	return route, true
+}
+
+// reinstallRoutesLocked reinstalls the routes of the multicast routing
+// endpoint in the stack, after its virtual interfaces changed.
+//
+// Precondition: m.mu must be locked.
+func (m *multicastRouter) reinstallRoutesLocked(s *stack.Stack, netProto tcpip.NetworkProtocolNumber) {
+	for addresses, r := range m.routes {
+		if route, ok := m.stackRouteLocked(&r.option); ok {
+			_ = s.AddMulticastRoute(netProto, addresses, route)
+		} else {
+			_ = s.RemoveMulticastRoute(netProto, addresses)
+		}
+	}
+}
+
+// interfaceIndexLocked returns the index of the virtual interface of the given
+// NIC.
+//
+// Precondition: m.mu must be locked.
+func (m *multicastRouter) interfaceIndexLocked(nicID tcpip.NICID) (uint16, bool) {
+	for index, id := range m.interfaces {
+		if id == nicID {
+			return index, true
+		}
+	}
+	return 0, false
+}
+
+// OnMissingRoute implements stack.MulticastForwardingEventDispatcher.
+func (e *endpoint) OnMissingRoute(context stack.MulticastPacketContext) {
+	m := &e.mrouter
+	m.mu.Lock()
+	index, ok := m.interfaceIndexLocked(context.InputInterface)
+	m.mu.Unlock()
+
+	// As per Linux, packets arriving on NICs that are not virtual interfaces
+	// are not reported.
+	if !ok {
+		return
+	}
+	e.deliverMulticastMessage(multicastMessageNoCache, index, context)
+}
+
+// OnUnexpectedInputInterface implements
+// stack.MulticastForwardingEventDispatcher.
+func (e *endpoint) OnUnexpectedInputInterface(context stack.MulticastPacketContext, _ tcpip.NICID) {
+	m := &e.mrouter
+	m.mu.Lock()
+	index, ok := m.interfaceIndexLocked(context.InputInterface)
+	if !ok || !m.assert {
+		m.mu.Unlock()
+		return
+	}
+
+	// As per Linux, only the packets arriving on one of the outgoing
+	// interfaces of the route are reported, at most once per
+	// multicastAssertInterval.
+	r, ok := m.routes[context.SourceAndDestination]
+	if !ok || int(index) >= len(r.option.TTLs) || r.option.TTLs[index] == 0 || r.option.TTLs[index] == 255 {
+		m.mu.Unlock()
+		return
+	}
+	now := e.stack.Clock().NowMonotonic()
+	if r.lastAssert != 0 && now-r.lastAssert < multicastAssertInterval.Nanoseconds() {
+		m.mu.Unlock()
+		return
+	}
+	r.lastAssert = now
+	m.mu.Unlock()
+
+	e.deliverMulticastMessage(multicastMessageWrongVIF, index, context)
+}
+
+// deliverMulticastMessage queues a multicast routing notification on the
+// endpoint, in the format of Linux's struct igmpmsg or struct mrt6msg.
+func (e *endpoint) deliverMulticastMessage(msgType uint8, index uint16, context stack.MulticastPacketContext) {
+	src := context.SourceAndDestination.Source
+	dst := context.SourceAndDestination.Destination
+
+	var msg buffer.View
+	switch e.NetProto {
+	case header.IPv4ProtocolNumber:
+		// struct igmpmsg overlays an IPv4 header with a zero protocol, and is
+		// followed by an IGMP header with the message type.
+		msg = buffer.NewView(ipv4MulticastMessageSize)
+		ip := header.IPv4(msg)
+		ip.Encode(&header.IPv4Fields{
+			TotalLength: ipv4MulticastMessageSize,
+			SrcAddr:     src,
+			DstAddr:     dst,
+		})
+		msg[8] = msgType
+		msg[10] = uint8(index)
+		msg[11] = uint8(index >> 8)
+		header.IGMP(msg[header.IPv4MinimumSize:]).SetType(header.IGMPType(msgType))
+	case header.IPv6ProtocolNumber:
+		msg = buffer.NewView(ipv6MulticastMessageSize)
+		msg[1] = msgType
+		// The interface index is in host byte order, which is little endian
+		// on all the architectures netstack supports.
+		binary.LittleEndian.PutUint16(msg[2:], index)
+		copy(msg[8:], src)
+		copy(msg[8+header.IPv6AddressSize:], dst)
+	default:
+		return
+	}
+
+	e.rcvMu.Lock()
+	if e.rcvClosed {
+		e.rcvMu.Unlock()
+		e.stack.Stats().DroppedPackets.Increment()
+		e.stats.ReceiveErrors.ClosedReceiver.Increment()
+		return
+	}
+	if e.rcvBufSize >= e.rcvBufSizeMax {
+		e.rcvMu.Unlock()
+		e.stack.Stats().DroppedPackets.Increment()
+		e.stats.ReceiveErrors.ReceiveBufferOverflow.Increment()
+		return
+	}
+
+	wasEmpty := e.rcvBufSize == 0
+	packet := &rawPacket{
+		data: msg.ToVectorisedView(),
+		senderAddr: tcpip.FullAddress{
+			NIC:  context.InputInterface,
+			Addr: src,
+		},
+		timestampNS: e.stack.Clock().NowNanoseconds(),
+	}
+	e.rcvList.PushBack(packet)
+	e.rcvBufSize += packet.data.Size()
+	e.rcvMu.Unlock()
+
+	if wasEmpty {
+		e.waiterQueue.Notify(waiter.EventIn)
+	}
+}
@@ -29,6 +29,11 @@ func (EndpointFactory) NewUnassociatedEndpoint(stack *stack.Stack, netProto tcpi
 	return newEndpoint(stack, netProto, transProto, waiterQueue, false /* associated */)
 }
 
+// NewAssociatedEndpoint implements stack.RawFactory.NewAssociatedEndpoint.
+func (EndpointFactory) NewAssociatedEndpoint(stack *stack.Stack, netProto tcpip.NetworkProtocolNumber, transProto tcpip.TransportProtocolNumber, waiterQueue *waiter.Queue) (tcpip.Endpoint, *tcpip.Error) {
+	return newEndpoint(stack, netProto, transProto, waiterQueue, true /* associated */)
+}
+
 // NewPacketEndpoint implements stack.RawFactory.NewPacketEndpoint.
 func (EndpointFactory) NewPacketEndpoint(stack *stack.Stack, cooked bool, netProto tcpip.NetworkProtocolNumber, waiterQueue *waiter.Queue) (tcpip.Endpoint, *tcpip.Error) {
 	return packet.NewEndpoint(stack, cooked, netProto, waiterQueue)
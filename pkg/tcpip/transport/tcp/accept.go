@@ -206,13 +206,17 @@ func (l *listenContext) createConnectingEndpoint(s *segment, iss seqnum.Value, i
 		netProto = s.netProto
 	}
 
-	route, err := l.stack.FindRoute(s.nicID, s.dstAddr, s.srcAddr, s.netProto, false /* multicastLoop */)
+	// Connections accepted by a transparent listener may use the
+	// non-local address the SYN was diverted from.
+	transparent := l.listenEP != nil && l.listenEP.ops.GetTransparent()
+	route, err := l.stack.FindRouteWithOptions(s.nicID, s.dstAddr, s.srcAddr, s.netProto, false /* multicastLoop */, stack.RouteLookupOptions{Transparent: transparent})
 	if err != nil {
 		return nil, err
 	}
 
 	n := newEndpoint(l.stack, netProto, queue)
 	n.ops.SetV6Only(l.v6Only)
+	n.ops.SetTransparent(transparent)
 	n.ID = s.id
 	n.boundNICID = s.nicID
 	n.route = route
@@ -305,6 +309,10 @@ func (l *listenContext) startHandshake(s *segment, opts *header.TCPSynOptions, q
 
 	// Initialize and start the handshake.
 	h := ep.newPassiveHandshake(isn, irs, opts, deferAccept)
+	h.acceptECN(s)
+	if l.listenEP != nil {
+		l.listenEP.handleFastOpenSyn(h, s, opts)
+	}
 	h.start()
 	return h, nil
 }
@@ -388,7 +396,7 @@ func (l *listenContext) cleanupCompletedHandshake(h *handshake) {
 
 // deliverAccepted delivers the newly-accepted endpoint to the listener. If the
 // endpoint has transitioned out of the listen state (acceptedChan is nil),
-// the new endpoint is closed instead.
+// the new endpoint is migrated to another listener or closed instead.
 func (e *endpoint) deliverAccepted(n *endpoint) {
 	e.mu.Lock()
 	e.pendingAccepted.Add(1)
@@ -399,7 +407,9 @@ func (e *endpoint) deliverAccepted(n *endpoint) {
 	for {
 		if e.acceptedChan == nil {
 			e.acceptMu.Unlock()
-			n.notifyProtocolGoroutine(notifyReset)
+			if !e.migrateAccepted(n) {
+				n.notifyProtocolGoroutine(notifyReset)
+			}
 			return
 		}
 		select {
@@ -413,6 +423,47 @@ func (e *endpoint) deliverAccepted(n *endpoint) {
 	}
 }
 
+// migrateAccepted migrates n, a connection that completed its handshake with e
+// but won't be accepted from e because e stopped listening, to another
+// listening endpoint of the SO_REUSEPORT group of e if TCPMigrateReqOption is
+// enabled. It returns false if n wasn't migrated and must be reset.
+func (e *endpoint) migrateAccepted(n *endpoint) bool {
+	var migrate tcpip.TCPMigrateReqOption
+	if err := e.stack.TransportProtocolOption(ProtocolNumber, &migrate); err != nil || !migrate {
+		return false
+	}
+
+	// n inherited the device binding of e, but e may have been unbound
+	// already.
+	ep := e.stack.FindMigrationEndpoint(n.NetProto, ProtocolNumber, n.ID, n.boundBindToDevice, e)
+	t, ok := ep.(*endpoint)
+	if !ok {
+		return false
+	}
+
+	// Connections are only migrated to listeners with room in their accept
+	// queue, so as not to block the endpoint being closed. Locking t.mu to
+	// account for the connections in SYN-RCVD on t could deadlock with t
+	// migrating its own connections to e.
+	t.acceptMu.Lock()
+	migrated := false
+	if t.acceptedChan != nil {
+		select {
+		case t.acceptedChan <- n:
+			migrated = true
+		default:
+		}
+	}
+	t.acceptMu.Unlock()
+	if !migrated {
+		e.stack.Stats().TCP.MigrateReqFailure.Increment()
+		return false
+	}
+	t.waiterQueue.Notify(waiter.EventIn)
+	e.stack.Stats().TCP.MigrateReqSuccess.Increment()
+	return true
+}
+
 // propagateInheritableOptionsLocked propagates any options set on the listening
 // endpoint to the newly created endpoint.
 //
@@ -423,6 +474,7 @@ func (e *endpoint) propagateInheritableOptionsLocked(n *endpoint) {
 	n.boundBindToDevice = e.boundBindToDevice
 	n.boundPortFlags = e.boundPortFlags
 	n.userMSS = e.userMSS
+	e.inheritMD5KeyLocked(n)
 }
 
 // reserveTupleLocked reserves an accepted endpoint's tuple.
@@ -486,12 +538,14 @@ func (e *endpoint) handleSynSegment(ctx *listenContext, s *segment, opts *header
 			ctx.cleanupFailedHandshake(h)
 			e.mu.Lock()
 			e.synRcvdCount--
+			h.fastOpenDone()
 			e.mu.Unlock()
 			return
 		}
 		ctx.cleanupCompletedHandshake(h)
 		e.mu.Lock()
 		e.synRcvdCount--
+		h.fastOpenDone()
 		e.mu.Unlock()
 		h.ep.startAcceptedLoop()
 		e.stack.Stats().TCP.PassiveConnectionOpenings.Increment()
@@ -511,6 +565,38 @@ func (e *endpoint) incSynRcvdCount() bool {
 	return canInc
 }
 
+// synCookiesMode returns when the endpoint answers SYNs with SYN cookies.
+func (e *endpoint) synCookiesMode() tcpip.TCPSynCookiesOption {
+	v := tcpip.TCPSynCookiesOnOverflow
+	if err := e.stack.TransportProtocolOption(ProtocolNumber, &v); err != nil {
+		return tcpip.TCPSynCookiesOnOverflow
+	}
+	return v
+}
+
+// synCookiesInUse returns true if ACKs received by the listening endpoint may
+// be for SYN cookies it sent.
+func (e *endpoint) synCookiesInUse(ctx *listenContext) bool {
+	switch e.synCookiesMode() {
+	case tcpip.TCPSynCookiesDisabled:
+		return false
+	case tcpip.TCPSynCookiesAlways:
+		return true
+	default:
+		return ctx.synRcvdCount.synCookiesInUse()
+	}
+}
+
+// abortOnOverflow returns true if the final ACK of handshakes is answered with
+// a RST when the accept queue is full.
+func (e *endpoint) abortOnOverflow() bool {
+	var v tcpip.TCPAbortOnOverflowOption
+	if err := e.stack.TransportProtocolOption(ProtocolNumber, &v); err != nil {
+		return false
+	}
+	return bool(v)
+}
+
 func (e *endpoint) acceptQueueIsFull() bool {
 	e.acceptMu.Lock()
 	full := len(e.acceptedChan)+e.synRcvdCount >= cap(e.acceptedChan)
@@ -536,9 +622,10 @@ func (e *endpoint) handleListenSegment(ctx *listenContext, s *segment) *tcpip.Er
 	}
 
 	switch {
-	case s.flags == header.TCPFlagSyn:
+	case s.flags&^ecnFlags == header.TCPFlagSyn:
 		opts := parseSynSegmentOptions(s)
-		if ctx.synRcvdCount.inc() {
+		synCookies := e.synCookiesMode()
+		if synCookies != tcpip.TCPSynCookiesAlways && ctx.synRcvdCount.inc() {
 			// Only handle the syn if the following conditions hold
 			//   - accept queue is not full.
 			//   - number of connections in synRcvd state is less than the
@@ -554,9 +641,9 @@ func (e *endpoint) handleListenSegment(ctx *listenContext, s *segment) *tcpip.Er
 			e.stack.Stats().DroppedPackets.Increment()
 			return nil
 		} else {
-			// If cookies are in use but the endpoint accept queue
-			// is full then drop the syn.
-			if e.acceptQueueIsFull() {
+			// If cookies are disabled, or in use but the endpoint
+			// accept queue is full, then drop the syn.
+			if synCookies == tcpip.TCPSynCookiesDisabled || e.acceptQueueIsFull() {
 				e.stack.Stats().TCP.ListenOverflowSynDrop.Increment()
 				e.stats.ReceiveErrors.ListenOverflowSynDrop.Increment()
 				e.stack.Stats().DroppedPackets.Increment()
@@ -564,7 +651,7 @@ func (e *endpoint) handleListenSegment(ctx *listenContext, s *segment) *tcpip.Er
 			}
 			cookie := ctx.createCookie(s.id, s.sequenceNumber, encodeMSS(opts.MSS))
 
-			route, err := e.stack.FindRoute(s.nicID, s.dstAddr, s.srcAddr, s.netProto, false /* multicastLoop */)
+			route, err := e.stack.FindRouteWithOptions(s.nicID, s.dstAddr, s.srcAddr, s.netProto, false /* multicastLoop */, stack.RouteLookupOptions{Transparent: e.ops.GetTransparent()})
 			if err != nil {
 				return err
 			}
@@ -603,6 +690,13 @@ func (e *endpoint) handleListenSegment(ctx *listenContext, s *segment) *tcpip.Er
 
 	case (s.flags & header.TCPFlagAck) != 0:
 		if e.acceptQueueIsFull() {
+			if e.abortOnOverflow() {
+				// The connection is aborted rather than
+				// completed later, as the sender is told it
+				// was refused.
+				e.stack.Stats().TCP.ListenOverflowAckReset.Increment()
+				return replyWithReset(e.stack, s, e.sendTOS, e.ttl)
+			}
 			// Silently drop the ack as the application can't accept
 			// the connection at this point. The ack will be
 			// retransmitted by the sender anyway and we can
@@ -614,7 +708,7 @@ func (e *endpoint) handleListenSegment(ctx *listenContext, s *segment) *tcpip.Er
 			return nil
 		}
 
-		if !ctx.synRcvdCount.synCookiesInUse() {
+		if !e.synCookiesInUse(ctx) {
 			// When not using SYN cookies, as per RFC 793, section 3.9, page 64:
 			// Any acknowledgment is bad if it arrives on a connection still in
 			// the LISTEN state.  An acceptable reset segment should be formed
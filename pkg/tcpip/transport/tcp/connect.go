@@ -71,6 +71,9 @@ type handshake struct {
 	flags  uint8
 	ackNum seqnum.Value
 
+	// ae is the AE (Accurate ECN) flag of the SYN/SYN-ACK.
+	ae bool
+
 	// iss is the initial send sequence number, as defined in RFC 793.
 	iss seqnum.Value
 
@@ -105,6 +108,29 @@ type handshake struct {
 
 	// sendSYNOpts is the cached values for the SYN options to be sent.
 	sendSYNOpts header.TCPSynOptions
+
+	// fastOpen is true if the SYN/SYN-ACK carries a TCP Fast Open option
+	// with fastOpenCookie. An empty cookie is a cookie request.
+	fastOpen       bool
+	fastOpenCookie []byte
+
+	// fastOpenData is the data sent in the SYN of an active TCP Fast Open
+	// handshake. It is only sent with the first SYN, retransmitted SYNs
+	// carry no data.
+	fastOpenData buffer.View
+
+	// fastOpenAcked is the number of bytes of fastOpenData acknowledged by
+	// the peer's SYN-ACK.
+	fastOpenAcked seqnum.Size
+
+	// fastOpenRcvd holds the data received in the SYN of a passive TCP Fast
+	// Open handshake. It has already been acknowledged and is delivered to
+	// the endpoint once the handshake completes.
+	fastOpenRcvd *segment
+
+	// fastOpenListener is the listening endpoint whose TCP Fast Open queue
+	// this passive handshake is counted against.
+	fastOpenListener *endpoint
 }
 
 func (e *endpoint) newHandshake() *handshake {
@@ -153,9 +179,12 @@ func (h *handshake) resetState() {
 
 	h.state = handshakeSynSent
 	h.flags = header.TCPFlagSyn
+	h.ae = false
+	h.requestECN()
 	h.ackNum = 0
 	h.mss = 0
 	h.iss = generateSecureISN(h.ep.ID, h.ep.stack.Seed())
+	h.fastOpenData = nil
 }
 
 // generateSecureISN generates a secure Initial Sequence number based on the
@@ -199,6 +228,7 @@ func (h *handshake) resetToSynRcvd(iss seqnum.Value, irs seqnum.Value, opts *hea
 	h.active = false
 	h.state = handshakeSynRcvd
 	h.flags = header.TCPFlagSyn | header.TCPFlagAck
+	h.ae = false
 	h.iss = iss
 	h.ackNum = irs + 1
 	h.mss = opts.MSS
@@ -211,7 +241,8 @@ func (h *handshake) resetToSynRcvd(iss seqnum.Value, irs seqnum.Value, opts *hea
 // a TCP 3-way handshake is valid. If it's not, a RST segment is sent back in
 // response.
 func (h *handshake) checkAck(s *segment) bool {
-	if s.flagIsSet(header.TCPFlagAck) && s.ackNumber != h.iss+1 {
+	// The ACK may also cover data sent in a TCP Fast Open SYN.
+	if s.flagIsSet(header.TCPFlagAck) && (h.iss+1).Size(s.ackNumber) > seqnum.Size(len(h.fastOpenData)) {
 		// RFC 793, page 36, states that a reset must be generated when
 		// the connection is in any non-synchronized state and an
 		// incoming segment acknowledges something not yet sent. The
@@ -261,8 +292,15 @@ func (h *handshake) synSentState(s *segment) *tcpip.Error {
 	// Remember if the SACKPermitted option was negotiated.
 	h.ep.maybeEnableSACKPermitted(&rcvSynOpts)
 
-	// Remember the sequence we'll ack from now on.
+	// Remember the sequence we'll ack from now on. ECN is only negotiated
+	// with a SYN-ACK, not in a simultaneous open.
 	h.ackNum = s.sequenceNumber + 1
+	if s.flagIsSet(header.TCPFlagAck) {
+		h.connectECN(s)
+	} else {
+		h.flags &^= ecnFlags
+		h.ae = false
+	}
 	h.flags |= header.TCPFlagAck
 	h.mss = rcvSynOpts.MSS
 	h.sndWndScale = rcvSynOpts.WS
@@ -272,9 +310,16 @@ func (h *handshake) synSentState(s *segment) *tcpip.Error {
 	if s.flagIsSet(header.TCPFlagAck) {
 		h.state = handshakeCompleted
 
+		// Any data sent in a TCP Fast Open SYN that the peer did
+		// not acknowledge is sent again once established.
+		h.fastOpenAcked = h.fastOpenSynAcked(s, &rcvSynOpts)
+
 		h.ep.transitionToStateEstablishedLocked(h)
+		if h.fastOpenAcked > 0 {
+			h.ep.trimFastOpenDataLocked(h.fastOpenAcked)
+		}
 
-		h.ep.sendRaw(buffer.VectorisedView{}, header.TCPFlagAck, h.iss+1, h.ackNum, h.rcvWnd>>h.effectiveRcvWndScale())
+		h.ep.sendRaw(buffer.VectorisedView{}, header.TCPFlagAck, h.iss.Add(h.fastOpenAcked)+1, h.ackNum, h.rcvWnd>>h.effectiveRcvWndScale())
 		return nil
 	}
 
@@ -337,7 +382,13 @@ func (h *handshake) synRcvdState(s *segment) *tcpip.Error {
 		return nil
 	}
 
-	if s.flagIsSet(header.TCPFlagSyn) && s.sequenceNumber != h.ackNum-1 {
+	// The SYN sequence number precedes any TCP Fast Open data that was
+	// acknowledged along with the SYN.
+	synSeq := h.ackNum - 1
+	if h.fastOpenRcvd != nil {
+		synSeq -= seqnum.Value(h.fastOpenRcvd.data.Size())
+	}
+	if s.flagIsSet(header.TCPFlagSyn) && s.sequenceNumber != synSeq {
 		// We received two SYN segments with different sequence
 		// numbers, so we reset this and restart the whole
 		// process, except that we don't reset the timer.
@@ -366,6 +417,7 @@ func (h *handshake) synRcvdState(s *segment) *tcpip.Error {
 			ttl:    h.ep.ttl,
 			tos:    h.ep.sendTOS,
 			flags:  h.flags,
+			ae:     h.ae,
 			seq:    h.iss,
 			ack:    h.ackNum,
 			rcvWnd: h.rcvWnd,
@@ -400,6 +452,15 @@ func (h *handshake) synRcvdState(s *segment) *tcpip.Error {
 
 		h.ep.transitionToStateEstablishedLocked(h)
 
+		// Deliver the data received in a TCP Fast Open SYN, it was
+		// already acknowledged by our SYN-ACK.
+		if d := h.fastOpenRcvd; d != nil {
+			h.fastOpenRcvd = nil
+			d.setOwner(h.ep, recvQ)
+			h.ep.readyToRead(d)
+			d.decRef()
+		}
+
 		// If the segment has data then requeue it for the receiver
 		// to process it again once main loop is started.
 		if s.data.Size() > 0 {
@@ -492,16 +553,27 @@ func (h *handshake) start() {
 		}
 	}
 
+	if h.fastOpen {
+		synOpts.FastOpen = true
+		synOpts.FastOpenCookie = h.fastOpenCookie
+	}
+
 	h.sendSYNOpts = synOpts
-	h.ep.sendSynTCP(h.ep.route, tcpFields{
+	tf := tcpFields{
 		id:     h.ep.ID,
 		ttl:    h.ep.ttl,
 		tos:    h.ep.sendTOS,
 		flags:  h.flags,
+		ae:     h.ae,
 		seq:    h.iss,
 		ack:    h.ackNum,
 		rcvWnd: h.rcvWnd,
-	}, synOpts)
+	}
+	if len(h.fastOpenData) != 0 {
+		h.ep.sendSynDataTCP(h.ep.route, tf, synOpts, h.fastOpenData)
+		return
+	}
+	h.ep.sendSynTCP(h.ep.route, tf, synOpts)
 }
 
 // complete completes the TCP 3-way handshake initiated by h.start().
@@ -520,6 +592,7 @@ func (h *handshake) complete() *tcpip.Error {
 		return err
 	}
 	defer timer.stop()
+	h.capResendTimer(timer)
 	for h.state != handshakeCompleted {
 		// Unlock before blocking, and reacquire again afterwards (h.ep.mu is held
 		// throughout handshake processing).
@@ -529,9 +602,13 @@ func (h *handshake) complete() *tcpip.Error {
 		switch index {
 
 		case wakerForResend:
+			if h.userTimeoutExpired() {
+				return tcpip.ErrTimeout
+			}
 			if err := timer.reset(); err != nil {
 				return err
 			}
+			h.capResendTimer(timer)
 			// Resend the SYN/SYN-ACK only if the following conditions hold.
 			//  - It's an active handshake (deferAccept does not apply)
 			//  - It's a passive handshake and we have not yet got the final-ACK.
@@ -541,11 +618,15 @@ func (h *handshake) complete() *tcpip.Error {
 			// the connection with another ACK or data (as ACKs are never
 			// retransmitted on their own).
 			if h.active || !h.acked || h.deferAccept != 0 && time.Since(h.startTime) > h.deferAccept {
+				if h.active {
+					h.ecnFallback()
+				}
 				h.ep.sendSynTCP(h.ep.route, tcpFields{
 					id:     h.ep.ID,
 					ttl:    h.ep.ttl,
 					tos:    h.ep.sendTOS,
 					flags:  h.flags,
+					ae:     h.ae,
 					seq:    h.iss,
 					ack:    h.ackNum,
 					rcvWnd: h.rcvWnd,
@@ -587,6 +668,26 @@ func (h *handshake) complete() *tcpip.Error {
 	return nil
 }
 
+// userTimeoutExpired returns true if the handshake is an active open and the
+// user specified timeout has elapsed since the first SYN was sent. Like Linux,
+// TCP_USER_TIMEOUT overrides the SYN retransmission limit.
+//
+// See net/ipv4/tcp_timer.c::tcp_write_timeout().
+func (h *handshake) userTimeoutExpired() bool {
+	return h.active && h.ep.userTimeout != 0 && time.Since(h.startTime) >= h.ep.userTimeout
+}
+
+// capResendTimer shortens the next SYN retransmission of an active open so that
+// it does not fire after the user specified timeout.
+func (h *handshake) capResendTimer(bt *backoffTimer) {
+	if !h.active || h.ep.userTimeout == 0 {
+		return
+	}
+	if remaining := h.ep.userTimeout - time.Since(h.startTime); remaining < bt.timeout {
+		bt.t.Reset(remaining)
+	}
+}
+
 type backoffTimer struct {
 	timeout    time.Duration
 	maxTimeout time.Duration
@@ -683,11 +784,17 @@ func makeSynOptions(opts header.TCPSynOptions) []byte {
 		offset += header.EncodeWSOption(opts.WS, options[offset:])
 	}
 
+	if opts.FastOpen {
+		offset += header.EncodeFastOpenOption(opts.FastOpenCookie, options[offset:])
+	}
+
 	// Padding to the end; note that this never apply unless we add a
 	// fastopen option, we always expect the offset to remain the same.
-	if delta := header.AddTCPOptionPadding(options, offset); delta != 0 {
+	delta := header.AddTCPOptionPadding(options, offset)
+	if delta != 0 && !opts.FastOpen {
 		panic("unexpected option encoding")
 	}
+	offset += delta
 
 	return options[:offset]
 }
@@ -699,11 +806,13 @@ type tcpFields struct {
 	ttl    uint8
 	tos    uint8
 	flags  byte
+	ae     bool
 	seq    seqnum.Value
 	ack    seqnum.Value
 	rcvWnd seqnum.Size
 	opts   []byte
 	txHash uint32
+	md5Key []byte
 }
 
 func (e *endpoint) sendSynTCP(r *stack.Route, tf tcpFields, opts header.TCPSynOptions) *tcpip.Error {
@@ -718,6 +827,10 @@ func (e *endpoint) sendSynTCP(r *stack.Route, tf tcpFields, opts header.TCPSynOp
 
 func (e *endpoint) sendTCP(r *stack.Route, tf tcpFields, data buffer.VectorisedView, gso *stack.GSO) *tcpip.Error {
 	tf.txHash = e.txHash
+	if signed := e.addMD5Option(tf); signed.md5Key != nil {
+		tf = signed
+		defer putOptions(tf.opts)
+	}
 	if err := sendTCP(r, tf, data, gso, e.owner); err != nil {
 		e.stats.SendErrors.SegmentSendToNetworkFailed.Increment()
 		return err
@@ -737,9 +850,13 @@ func buildTCPHdr(r *stack.Route, tf tcpFields, pkt *stack.PacketBuffer, gso *sta
 		AckNum:     uint32(tf.ack),
 		DataOffset: uint8(header.TCPMinimumSize + optLen),
 		Flags:      tf.flags,
+		AE:         tf.ae,
 		WindowSize: uint16(tf.rcvWnd),
 	})
 	copy(tcp[header.TCPMinimumSize:], tf.opts)
+	if tf.md5Key != nil {
+		signTCP(r, tcp, pkt.Data, tf.md5Key)
+	}
 
 	xsum := r.PseudoHeaderChecksum(ProtocolNumber, uint16(pkt.Size()))
 	// Only calculate the checksum if offloading isn't supported.
@@ -861,9 +978,18 @@ func (e *endpoint) makeOptions(sackBlocks []header.SACKBlock) []byte {
 		offset += header.EncodeTSOption(e.timestamp(), e.recentTimestamp(), options[offset:])
 	}
 	if e.sackPermitted && len(sackBlocks) > 0 {
-		offset += header.EncodeNOP(options[offset:])
-		offset += header.EncodeNOP(options[offset:])
-		offset += header.EncodeSACKBlocks(sackBlocks, options[offset:])
+		// Leave room for the MD5 signature option, if any, which is
+		// added when the segment is sent.
+		limit := len(options)
+		if e.md5Key(e.ID.RemoteAddress) != nil {
+			limit -= md5OptionSize
+		}
+		// Two NOPs and the SACK option with a single block.
+		if limit-offset >= 2+2+8 {
+			offset += header.EncodeNOP(options[offset:])
+			offset += header.EncodeNOP(options[offset:])
+			offset += header.EncodeSACKBlocks(sackBlocks, options[offset:limit])
+		}
 	}
 
 	// We expect the above to produce an aligned offset.
@@ -876,6 +1002,13 @@ func (e *endpoint) makeOptions(sackBlocks []header.SACKBlock) []byte {
 
 // sendRaw sends a TCP segment to the endpoint's peer.
 func (e *endpoint) sendRaw(data buffer.VectorisedView, flags byte, seq, ack seqnum.Value, rcvWnd seqnum.Size) *tcpip.Error {
+	return e.sendRawECT(data, flags, seq, ack, rcvWnd, false /* ect */)
+}
+
+// sendRawECT is like sendRaw, but the segment is sent as ECN-capable if ect is
+// true and ECN is in use.
+func (e *endpoint) sendRawECT(data buffer.VectorisedView, flags byte, seq, ack seqnum.Value, rcvWnd seqnum.Size, ect bool) *tcpip.Error {
+	flags, ae, codepoint := e.ecnFields(flags, ect)
 	var sackBlocks []header.SACKBlock
 	if e.EndpointState() == StateEstablished && e.rcv.pendingRcvdSegments.Len() > 0 && (flags&header.TCPFlagAck != 0) {
 		sackBlocks = e.sack.Blocks[:e.sack.NumBlocks]
@@ -884,8 +1017,9 @@ func (e *endpoint) sendRaw(data buffer.VectorisedView, flags byte, seq, ack seqn
 	err := e.sendTCP(e.route, tcpFields{
 		id:     e.ID,
 		ttl:    e.ttl,
-		tos:    e.sendTOS,
+		tos:    e.sendTOS | codepoint,
 		flags:  flags,
+		ae:     ae,
 		seq:    seq,
 		ack:    ack,
 		rcvWnd: rcvWnd,
@@ -980,7 +1114,7 @@ func (e *endpoint) transitionToStateEstablishedLocked(h *handshake) {
 	// Transfer handshake state to TCP connection. We disable
 	// receive window scaling if the peer doesn't support it
 	// (indicated by a negative send window scale).
-	e.snd = newSender(e, h.iss, h.ackNum-1, h.sndWnd, h.mss, h.sndWndScale)
+	e.snd = newSender(e, h.iss.Add(h.fastOpenAcked), h.ackNum-1, h.sndWnd, h.mss, h.sndWndScale)
 
 	e.rcvListMu.Lock()
 	e.rcv = newReceiver(e, h.ackNum-1, h.rcvWnd, h.effectiveRcvWndScale())
@@ -1190,6 +1324,7 @@ func (e *endpoint) handleSegment(s *segment) (cont bool, err *tcpip.Error) {
 		// RFC 793, page 41 states that "once in the ESTABLISHED
 		// state all segments must carry current acknowledgment
 		// information."
+		e.rcvdECN(s)
 		drop, err := e.rcv.handleRcvdSegment(s)
 		if err != nil {
 			return false, err
@@ -1249,7 +1384,7 @@ func (e *endpoint) keepaliveTimerExpired() *tcpip.Error {
 	// seg.seq = snd.nxt-1.
 	e.keepalive.unacked++
 	e.keepalive.Unlock()
-	e.snd.sendSegmentFromView(buffer.VectorisedView{}, header.TCPFlagAck, e.snd.sndNxt-1)
+	e.snd.sendSegmentFromView(buffer.VectorisedView{}, header.TCPFlagAck, e.snd.sndNxt-1, false /* ect */)
 	e.resetKeepaliveTimer(false)
 	return nil
 }
@@ -1446,6 +1581,10 @@ func (e *endpoint) protocolMainLoop(handshake bool, wakerInitDone chan<- struct{
 					}
 				}
 
+				if n&notifyResync != 0 {
+					e.snd.resync()
+				}
+
 				if n&notifyTickleWorker != 0 {
 					// Just a tickle notification. No need to do
 					// anything.
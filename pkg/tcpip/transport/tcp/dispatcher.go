@@ -175,6 +175,13 @@ func (d *dispatcher) wait() {
 func (d *dispatcher) queuePacket(stackEP stack.TransportEndpoint, id stack.TransportEndpointID, pkt *stack.PacketBuffer) {
 	ep := stackEP.(*endpoint)
 
+	// As in Linux, only transparent endpoints accept the packets diverted by
+	// the TPROXY target.
+	if pkt.TProxy.Port != 0 && !ep.ops.GetTransparent() {
+		ep.stack.Stats().DroppedPackets.Increment()
+		return
+	}
+
 	s := newIncomingSegment(id, pkt)
 	if !s.parse(pkt.RXTransportChecksumValidated) {
 		ep.stack.Stats().MalformedRcvdPackets.Increment()
@@ -192,6 +199,13 @@ func (d *dispatcher) queuePacket(stackEP stack.TransportEndpoint, id stack.Trans
 		return
 	}
 
+	if !ep.checkMD5(s) {
+		ep.stack.Stats().DroppedPackets.Increment()
+		ep.stack.Stats().TCP.MD5SignatureErrors.Increment()
+		s.decRef()
+		return
+	}
+
 	ep.stack.Stats().TCP.ValidSegmentsReceived.Increment()
 	ep.stats.SegmentsReceived.Increment()
 	if (s.flags & header.TCPFlagRst) != 0 {
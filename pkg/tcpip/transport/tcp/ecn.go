@@ -0,0 +1,301 @@
+// Copyright 2021 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tcp
+
+import (
+	"gvisor.dev/gvisor/pkg/tcpip"
+	"gvisor.dev/gvisor/pkg/tcpip/header"
+	"gvisor.dev/gvisor/pkg/tcpip/seqnum"
+)
+
+// ecnFlags are the TCP flags used to negotiate ECN in a SYN and to signal
+// congestion once it has been negotiated.
+const ecnFlags = header.TCPFlagEce | header.TCPFlagCwr
+
+const (
+	// aceMask is the mask of the 3-bit ACE field formed by the AE, CWR and
+	// ECE flags when Accurate ECN is in use.
+	aceMask = 0x7
+
+	// aceInit is the initial value of the Accurate ECN CE packet counters.
+	//
+	// See: https://tools.ietf.org/html/draft-ietf-tcpm-accurate-ecn-13#section-3.2.2.
+	aceInit = 5
+)
+
+// ecnMode is the form of ECN in use by a connection.
+type ecnMode uint8
+
+const (
+	// ecnOff indicates ECN is not in use.
+	ecnOff ecnMode = iota
+
+	// ecnClassic indicates ECN is in use as specified in RFC 3168.
+	ecnClassic
+
+	// ecnAccurate indicates Accurate ECN is in use.
+	ecnAccurate
+)
+
+// ecnState holds the ECN state of an endpoint. It is only accessed by the
+// protocol goroutine or with the endpoint lock held.
+//
+// +stateify savable
+type ecnState struct {
+	// mode is the form of ECN negotiated for the connection.
+	mode ecnMode
+
+	// sendECE is set by a classic ECN receiver when a CE marked segment is
+	// received. ECE is then set on all ACKs until the peer signals CWR.
+	sendECE bool
+
+	// sendCWR is set by a classic ECN sender when it reduced its congestion
+	// window in response to ECE. CWR is set on the next new data segment.
+	sendCWR bool
+
+	// recover is the highest sequence number sent when the congestion
+	// window was last reduced in response to congestion signals. Signals
+	// for segments sent up to recover are not responded to again, so that
+	// the window is reduced at most once per round trip.
+	recover seqnum.Value
+
+	// rcvCE is the number of CE marked packets received when Accurate ECN is
+	// in use, the r.cep counter.
+	rcvCE uint32
+
+	// sndCE is the number of CE marked packets reported by the peer when
+	// Accurate ECN is in use, the s.cep counter.
+	sndCE uint32
+
+	// handshakeACE, if non-zero, is the ACE value sent in the next ACK
+	// instead of rcvCE. It reports the ECN codepoint of the SYN-ACK that
+	// completed an active Accurate ECN handshake.
+	handshakeACE uint8
+}
+
+// ecnOption returns the ECN mode of the stack.
+func (e *endpoint) ecnOption() tcpip.TCPECNOption {
+	var v tcpip.TCPECNOption
+	if err := e.stack.TransportProtocolOption(ProtocolNumber, &v); err != nil {
+		// If the stack does not report the ECN mode then just default
+		// to not negotiating it.
+		return tcpip.TCPECNDisabled
+	}
+	return v
+}
+
+// aceFlags returns the flags and AE flag that encode the ACE field value ace.
+func aceFlags(ace uint8) (uint8, bool) {
+	var flags uint8
+	if ace&0x2 != 0 {
+		flags |= header.TCPFlagCwr
+	}
+	if ace&0x1 != 0 {
+		flags |= header.TCPFlagEce
+	}
+	return flags, ace&0x4 != 0
+}
+
+// ace returns the value of the ACE field of s.
+func (s *segment) ace() uint32 {
+	var ace uint32
+	if s.ae {
+		ace |= 0x4
+	}
+	if s.flagIsSet(header.TCPFlagCwr) {
+		ace |= 0x2
+	}
+	if s.flagIsSet(header.TCPFlagEce) {
+		ace |= 0x1
+	}
+	return ace
+}
+
+// handshakeACE returns the ACE value that reports the ECN codepoint of a
+// SYN or SYN-ACK during an Accurate ECN handshake.
+//
+// See: https://tools.ietf.org/html/draft-ietf-tcpm-accurate-ecn-13#section-3.1.2.
+func handshakeACE(codepoint uint8) uint8 {
+	switch codepoint {
+	case header.ECNECT1:
+		return 0x3
+	case header.ECNECT0:
+		return 0x4
+	case header.ECNCE:
+		return 0x6
+	default:
+		return 0x2
+	}
+}
+
+// requestECN sets the flags of the SYN sent by the active handshake h to
+// request ECN, as configured on the stack.
+func (h *handshake) requestECN() {
+	switch h.ep.ecnOption() {
+	case tcpip.TCPECNEnabled, tcpip.TCPAccECNRequestECN:
+		h.flags |= ecnFlags
+	case tcpip.TCPAccECNEnabled:
+		h.flags |= ecnFlags
+		h.ae = true
+	}
+}
+
+// ecnFallback stops requesting ECN in retransmitted SYNs, in case the SYN was
+// dropped because of the flags, if the stack is configured to do so.
+func (h *handshake) ecnFallback() {
+	var fallback tcpip.TCPECNFallbackOption
+	if err := h.ep.stack.TransportProtocolOption(ProtocolNumber, &fallback); err != nil || !fallback {
+		return
+	}
+	h.flags &^= ecnFlags
+	h.ae = false
+}
+
+// connectECN completes the negotiation of ECN requested by the active
+// handshake h with the SYN-ACK synAck.
+func (h *handshake) connectECN(synAck *segment) {
+	if h.flags&ecnFlags != ecnFlags {
+		return
+	}
+	e := h.ep
+	switch {
+	case h.ae && (synAck.ae || synAck.flagIsSet(header.TCPFlagCwr)):
+		e.ecn.mode = ecnAccurate
+		e.ecn.handshakeACE = handshakeACE(synAck.ecn)
+	case synAck.flags&ecnFlags == header.TCPFlagEce:
+		e.ecn.mode = ecnClassic
+	default:
+		return
+	}
+	e.ecn.rcvCE = aceInit
+	e.ecn.sndCE = aceInit
+	e.ecn.recover = h.iss
+}
+
+// acceptECN negotiates ECN as requested by syn for the passive handshake h,
+// and sets the flags of the SYN-ACK accordingly.
+func (h *handshake) acceptECN(syn *segment) {
+	if !syn.flagsAreSet(ecnFlags) {
+		return
+	}
+	e := h.ep
+	switch opt := e.ecnOption(); {
+	case opt == tcpip.TCPECNDisabled:
+		return
+	case syn.ae && opt >= tcpip.TCPAccECNEnabled:
+		// Report the ECN codepoint of the SYN in the ACE field of the
+		// SYN-ACK.
+		e.ecn.mode = ecnAccurate
+		flags, ae := aceFlags(handshakeACE(syn.ecn))
+		h.flags |= flags
+		h.ae = ae
+	default:
+		// A classic ECN SYN-ACK is also sent in response to an Accurate
+		// ECN SYN, as by RFC 3168 implementations.
+		e.ecn.mode = ecnClassic
+		h.flags |= header.TCPFlagEce
+	}
+	e.ecn.rcvCE = aceInit
+	e.ecn.sndCE = aceInit
+	e.ecn.recover = h.iss
+}
+
+// rcvdECN records the ECN signals of a segment received in a synchronized
+// state.
+func (e *endpoint) rcvdECN(s *segment) {
+	switch e.ecn.mode {
+	case ecnClassic:
+		// See: https://tools.ietf.org/html/rfc3168#section-6.1.3.
+		if s.flagIsSet(header.TCPFlagCwr) {
+			e.ecn.sendECE = false
+		}
+		if s.ecn == header.ECNCE && s.payloadSize() > 0 {
+			e.ecn.sendECE = true
+		}
+	case ecnAccurate:
+		if s.ecn == header.ECNCE {
+			e.ecn.rcvCE++
+		}
+	}
+}
+
+// ecnFields returns the flags, the AE flag and the ECN codepoint of a segment
+// with the provided flags sent in a synchronized state. ect is true if the
+// segment carries new data and may thus be sent as ECN-capable.
+func (e *endpoint) ecnFields(flags uint8, ect bool) (uint8, bool, uint8) {
+	if e.ecn.mode == ecnOff || flags&(header.TCPFlagSyn|header.TCPFlagRst) != 0 {
+		return flags, false, header.ECNNotECT
+	}
+
+	codepoint := uint8(header.ECNNotECT)
+	if ect {
+		codepoint = header.ECNECT0
+	}
+	if flags&header.TCPFlagAck == 0 {
+		return flags, false, codepoint
+	}
+
+	if e.ecn.mode == ecnClassic {
+		if e.ecn.sendECE {
+			flags |= header.TCPFlagEce
+		}
+		if e.ecn.sendCWR && ect {
+			flags |= header.TCPFlagCwr
+			e.ecn.sendCWR = false
+		}
+		return flags, false, codepoint
+	}
+
+	ace := uint8(e.ecn.rcvCE) & aceMask
+	if e.ecn.handshakeACE != 0 {
+		ace = e.ecn.handshakeACE
+		e.ecn.handshakeACE = 0
+	}
+	f, ae := aceFlags(ace)
+	return flags | f, ae, codepoint
+}
+
+// handleECNFeedback responds to the congestion signals carried by rcvdSeg,
+// an ACK that acknowledges new data.
+//
+// The congestion window is reduced as for a loss detected through duplicate
+// ACKs, without retransmitting anything, at most once per round trip. See RFC
+// 3168 section 6.1.2.
+func (s *sender) handleECNFeedback(rcvdSeg *segment) {
+	e := s.ep
+	var congested bool
+	switch e.ecn.mode {
+	case ecnClassic:
+		congested = rcvdSeg.flagIsSet(header.TCPFlagEce)
+	case ecnAccurate:
+		delta := (rcvdSeg.ace() - e.ecn.sndCE) & aceMask
+		e.ecn.sndCE += delta
+		congested = delta != 0
+	default:
+		return
+	}
+
+	if !congested || s.fr.active || rcvdSeg.ackNumber.LessThanEq(e.ecn.recover) {
+		return
+	}
+	s.cc.HandleNDupAcks()
+	s.sndCwnd = s.sndSsthresh
+	s.cc.PostRecovery()
+	e.ecn.recover = s.sndNxt
+	if e.ecn.mode == ecnClassic {
+		e.ecn.sendCWR = true
+	}
+	e.stack.Stats().TCP.ECNCongestionEvents.Increment()
+}
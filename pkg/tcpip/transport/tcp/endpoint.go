@@ -175,6 +175,9 @@ const (
 	// say TIME_WAIT.
 	notifyTickleWorker
 	notifyError
+	// notifyResync is used to resynchronize a connection with its peer
+	// after restore.
+	notifyResync
 )
 
 // SACKInfo holds TCP SACK related information for a given endpoint.
@@ -451,6 +454,10 @@ type endpoint struct {
 	// state.
 	origEndpointState EndpointState `state:"nosave"`
 
+	// saveTime is the time at which the endpoint was last saved. It is used
+	// on restore to determine how long a connection has been suspended.
+	saveTime time.Time `state:".(unixTime)"`
+
 	isPortReserved    bool `state:"manual"`
 	isRegistered      bool `state:"manual"`
 	boundNICID        tcpip.NICID
@@ -518,6 +525,10 @@ type endpoint struct {
 	// sack holds TCP SACK related information for this endpoint.
 	sack SACKInfo
 
+	// ecn holds the Explicit Congestion Notification state negotiated for
+	// this endpoint.
+	ecn ecnState
+
 	// delay enables Nagle's algorithm.
 	//
 	// delay is a boolean (0 is false) and must be accessed atomically.
@@ -550,6 +561,35 @@ type endpoint struct {
 	// this value.
 	windowClamp uint32
 
+	// fastOpenQueueLen is the maximum number of pending TCP Fast Open
+	// connections of a listening endpoint, as set using the TCP_FASTOPEN
+	// setsockopt. Zero disables TCP Fast Open for the listener.
+	fastOpenQueueLen int
+
+	// fastOpenPending is the number of connections of a listening endpoint
+	// that had their SYN data accepted and are still in SYN-RCVD state.
+	fastOpenPending int
+
+	// fastOpenConnect is true if connect(2) should use TCP Fast Open, as
+	// set using the TCP_FASTOPEN_CONNECT setsockopt.
+	fastOpenConnect bool
+
+	// fastOpenRequest is true if the next active handshake should request
+	// a TCP Fast Open cookie.
+	fastOpenRequest bool
+
+	// fastOpenDeferred is true if the endpoint is connecting but the SYN
+	// will only be sent, along with fastOpenCookie and data, on the first
+	// write.
+	fastOpenDeferred bool
+	fastOpenCookie   []byte
+
+	// md5Keys are the TCP MD5 signature keys installed using the
+	// TCP_MD5SIG setsockopt. They are protected by md5Mu rather than mu as
+	// they are used on the receive path before segments are queued.
+	md5Mu   sync.RWMutex `state:"nosave"`
+	md5Keys []md5Key
+
 	// The following fields are used to manage the send buffer. When
 	// segments are ready to be sent, they are added to sndQueue and the
 	// protocol goroutine is signaled via sndWaker.
@@ -947,7 +987,11 @@ func (e *endpoint) Readiness(mask waiter.EventMask) waiter.EventMask {
 		result |= waiter.EventHUp
 
 	case StateConnecting, StateSynSent, StateSynRecv:
-		// Ready for nothing.
+		// Ready for nothing, unless the SYN is deferred to the first
+		// write by TCP Fast Open.
+		if e.fastOpenDeferred {
+			result |= waiter.EventOut & mask
+		}
 
 	case StateClose, StateError, StateTimeWait:
 		// Ready for anything.
@@ -1140,9 +1184,11 @@ func (e *endpoint) closePendingAcceptableConnectionsLocked() {
 	e.acceptCond.Broadcast()
 	e.acceptMu.Unlock()
 
-	// Reset all connections that are waiting to be accepted.
+	// Migrate or reset all connections that are waiting to be accepted.
 	for n := range ch {
-		n.notifyProtocolGoroutine(notifyReset)
+		if !e.migrateAccepted(n) {
+			n.notifyProtocolGoroutine(notifyReset)
+		}
 	}
 	// Wait for reset of all endpoints that are still waiting to be delivered to
 	// the now closed acceptedChan.
@@ -1486,7 +1532,7 @@ func (e *endpoint) isEndpointWritableLocked() (int, *tcpip.Error) {
 		return 0, tcpip.ErrClosedForSend
 	case !s.connecting() && !s.connected():
 		return 0, tcpip.ErrClosedForSend
-	case s.connecting():
+	case s.connecting() && !e.fastOpenDeferred:
 		// As per RFC793, page 56, a send request arriving when in connecting
 		// state, can be queued to be completed after the state becomes
 		// connected. Return an error code for the caller of endpoint Write to
@@ -1511,6 +1557,25 @@ func (e *endpoint) Write(p tcpip.Payloader, opts tcpip.WriteOptions) (int64, *tc
 	// Linux completely ignores any address passed to sendto(2) for TCP sockets
 	// (without the MSG_FASTOPEN flag). Corking is unimplemented, so opts.More
 	// and opts.EndOfRecord are also ignored.
+	if opts.FastOpen && opts.To != nil {
+		e.LockUser()
+		state := e.EndpointState()
+		e.UnlockUser()
+		if state == StateInitial || state == StateBound {
+			// Without a cached cookie the handshake is started
+			// with a cookie request and the data is sent once the
+			// connection is established.
+			switch err := e.connectFastOpen(*opts.To); err {
+			case nil:
+			case tcpip.ErrConnectStarted:
+				return 0, err
+			default:
+				e.stack.Stats().TCP.FailedConnectionAttempts.Increment()
+				e.stats.FailedConnectionAttempts.Increment()
+				return 0, err
+			}
+		}
+	}
 
 	e.LockUser()
 	e.sndBufMu.Lock()
@@ -1575,9 +1640,17 @@ func (e *endpoint) Write(p tcpip.Payloader, opts tcpip.WriteOptions) (int64, *tc
 	e.sndBufUsed += len(v)
 	e.sndBufInQueue += seqnum.Size(len(v))
 	e.sndQueue.PushBack(s)
+
+	// If the SYN was deferred by TCP Fast Open, it is sent now along with
+	// the data. Otherwise do the work inline.
+	if e.fastOpenDeferred {
+		e.startFastOpenLocked()
+		e.sndBufMu.Unlock()
+		e.UnlockUser()
+		return int64(len(v)), nil
+	}
 	e.sndBufMu.Unlock()
 
-	// Do the work inline.
 	e.handleWrite()
 	e.UnlockUser()
 	return int64(len(v)), nil
@@ -1689,9 +1762,6 @@ func (e *endpoint) OnCorkOptionSet(v bool) {
 
 // SetSockOptInt sets a socket option.
 func (e *endpoint) SetSockOptInt(opt tcpip.SockOptInt, v int) *tcpip.Error {
-	// Lower 2 bits represents ECN bits. RFC 3168, section 23.1
-	const inetECNMask = 3
-
 	switch opt {
 	case tcpip.KeepaliveCountOption:
 		e.keepalive.Lock()
@@ -1701,16 +1771,14 @@ func (e *endpoint) SetSockOptInt(opt tcpip.SockOptInt, v int) *tcpip.Error {
 
 	case tcpip.IPv4TOSOption:
 		e.LockUser()
-		// TODO(gvisor.dev/issue/995): ECN is not currently supported,
-		// ignore the bits for now.
-		e.sendTOS = uint8(v) & ^uint8(inetECNMask)
+		// The ECN bits are managed by TCP, see RFC 3168 section 6.1.
+		e.sendTOS = uint8(v) &^ header.ECNMask
 		e.UnlockUser()
 
 	case tcpip.IPv6TrafficClassOption:
 		e.LockUser()
-		// TODO(gvisor.dev/issue/995): ECN is not currently supported,
-		// ignore the bits for now.
-		e.sendTOS = uint8(v) & ^uint8(inetECNMask)
+		// The ECN bits are managed by TCP, see RFC 3168 section 6.1.
+		e.sendTOS = uint8(v) &^ header.ECNMask
 		e.UnlockUser()
 
 	case tcpip.MaxSegOption:
@@ -1818,6 +1886,27 @@ func (e *endpoint) SetSockOptInt(opt tcpip.SockOptInt, v int) *tcpip.Error {
 		e.maxSynRetries = uint8(v)
 		e.UnlockUser()
 
+	case tcpip.TCPFastOpenOption:
+		if v < 0 {
+			return tcpip.ErrInvalidOptionValue
+		}
+		e.LockUser()
+		e.fastOpenQueueLen = v
+		e.UnlockUser()
+
+	case tcpip.TCPFastOpenConnectOption:
+		if v != 0 && v != 1 {
+			return tcpip.ErrInvalidOptionValue
+		}
+		e.LockUser()
+		defer e.UnlockUser()
+		switch e.EndpointState() {
+		case StateInitial, StateBound, StateClose, StateListen:
+			e.fastOpenConnect = v != 0
+		default:
+			return tcpip.ErrAlreadyConnected
+		}
+
 	case tcpip.TCPWindowClampOption:
 		if v == 0 {
 			e.LockUser()
@@ -1868,6 +1957,14 @@ func (e *endpoint) SetSockOpt(opt tcpip.SettableSocketOption) *tcpip.Error {
 		e.userTimeout = time.Duration(*v)
 		e.UnlockUser()
 
+	case *tcpip.TCPMD5SigOption:
+		e.LockUser()
+		defer e.UnlockUser()
+		if err := e.setMD5Key(v.Addr, v.PrefixLen, v.Key); err != nil {
+			return err
+		}
+		e.disableHardwareGSOForMD5Locked()
+
 	case *tcpip.CongestionControlOption:
 		// Query the available cc algorithms in the stack and
 		// validate that the specified algorithm is actually
@@ -1996,6 +2093,12 @@ func (e *endpoint) GetSockOptInt(opt tcpip.SockOptInt) (int, *tcpip.Error) {
 		e.sndBufMu.Unlock()
 		return v, nil
 
+	case tcpip.SendQueueSizeOption:
+		e.sndBufMu.Lock()
+		v := e.sndBufUsed
+		e.sndBufMu.Unlock()
+		return v, nil
+
 	case tcpip.ReceiveBufferSizeOption:
 		e.rcvListMu.Lock()
 		v := e.rcvBufSize
@@ -2020,6 +2123,21 @@ func (e *endpoint) GetSockOptInt(opt tcpip.SockOptInt) (int, *tcpip.Error) {
 		e.UnlockUser()
 		return v, nil
 
+	case tcpip.TCPFastOpenOption:
+		e.LockUser()
+		v := e.fastOpenQueueLen
+		e.UnlockUser()
+		return v, nil
+
+	case tcpip.TCPFastOpenConnectOption:
+		e.LockUser()
+		v := 0
+		if e.fastOpenConnect {
+			v = 1
+		}
+		e.UnlockUser()
+		return v, nil
+
 	case tcpip.MulticastTTLOption:
 		return 1, nil
 
@@ -2077,6 +2195,12 @@ func (e *endpoint) GetSockOpt(opt tcpip.GettableSocketOption) *tcpip.Error {
 		e.LockUser()
 		ipt := e.stack.IPTables()
 		addr, port, err := ipt.OriginalDst(e.ID, e.NetProto)
+		if err != nil && e.ops.GetTransparent() && e.EndpointState().connected() {
+			// Connections diverted by the TPROXY target are not
+			// redirected, their original destination is their local
+			// address.
+			addr, port, err = e.ID.LocalAddress, e.ID.LocalPort, nil
+		}
 		e.UnlockUser()
 		if err != nil {
 			return err
@@ -2109,7 +2233,16 @@ func (*endpoint) Disconnect() *tcpip.Error {
 
 // Connect connects the endpoint to its peer.
 func (e *endpoint) Connect(addr tcpip.FullAddress) *tcpip.Error {
-	err := e.connect(addr, true, true)
+	e.LockUser()
+	fastOpen := e.fastOpenConnect && (e.EndpointState() == StateInitial || e.EndpointState() == StateBound)
+	e.UnlockUser()
+
+	var err *tcpip.Error
+	if fastOpen {
+		err = e.connectFastOpen(addr)
+	} else {
+		err = e.connect(addr, true, true)
+	}
 	if err != nil && !err.IgnoreStats() {
 		// Connect failed. Let's wake up any waiters.
 		e.waiterQueue.Notify(waiter.EventHUp | waiter.EventErr | waiter.EventIn | waiter.EventOut)
@@ -2181,8 +2314,16 @@ func (e *endpoint) connect(addr tcpip.FullAddress, handshake bool, run bool) *tc
 		return tcpip.ErrInvalidEndpointState
 	}
 
+	// A socket bound to a device only routes through that device.
+	if bindToDevice := tcpip.NICID(e.ops.GetBindToDevice()); bindToDevice != 0 {
+		if nicID != 0 && nicID != bindToDevice {
+			return tcpip.ErrNoRoute
+		}
+		nicID = bindToDevice
+	}
+
 	// Find a route to the desired destination.
-	r, err := e.stack.FindRoute(nicID, e.ID.LocalAddress, addr.Addr, netProto, false /* multicastLoop */)
+	r, err := e.stack.FindRouteWithOptions(nicID, e.ID.LocalAddress, addr.Addr, netProto, false /* multicastLoop */, stack.RouteLookupOptions{Mark: e.ops.GetMark(), Transparent: e.ops.GetTransparent()})
 	if err != nil {
 		return err
 	}
@@ -2332,6 +2473,8 @@ func (e *endpoint) connect(addr tcpip.FullAddress, handshake bool, run bool) *tc
 	if run {
 		if handshake {
 			h := e.newHandshake()
+			h.fastOpen = e.fastOpenRequest
+			e.fastOpenRequest = false
 			e.setEndpointState(StateSynSent)
 			h.start()
 		}
@@ -2592,7 +2735,12 @@ func (e *endpoint) bindLocked(addr tcpip.FullAddress) (err *tcpip.Error) {
 	if len(addr.Addr) != 0 {
 		nic = e.stack.CheckLocalAddress(addr.NIC, netProto, addr.Addr)
 		if nic == 0 {
-			return tcpip.ErrBadLocalAddress
+			// Transparent endpoints may bind to addresses not assigned to
+			// the stack.
+			if !e.ops.GetTransparent() {
+				return tcpip.ErrBadLocalAddress
+			}
+			nic = addr.NIC
 		}
 		e.ID.LocalAddress = addr.Addr
 	}
@@ -2924,6 +3072,9 @@ func (e *endpoint) maxOptionSize() (size int) {
 	options := e.makeOptions(maxSackBlocks[:])
 	size = len(options)
 	putOptions(options)
+	if e.md5Key(e.ID.RemoteAddress) != nil {
+		size += md5OptionSize
+	}
 
 	return size
 }
@@ -3066,6 +3217,7 @@ func (e *endpoint) initGSO() {
 			NeedsCsum: false,
 		}
 	}
+	e.disableHardwareGSOForMD5Locked()
 }
 
 // State implements tcpip.Endpoint.State. It exports the endpoint's protocol
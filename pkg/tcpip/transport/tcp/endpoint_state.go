@@ -48,6 +48,7 @@ func (e *endpoint) beforeSave() {
 
 	e.mu.Lock()
 	defer e.mu.Unlock()
+	e.saveTime = time.Now()
 
 	epState := e.EndpointState()
 	switch {
@@ -235,7 +236,11 @@ func (e *endpoint) Resume(s *stack.Stack) {
 		e.state = e.origEndpointState
 		closed := e.closed
 		e.mu.Unlock()
-		e.notifyProtocolGoroutine(notifyTickleWorker)
+		if e.suspendedTooLong() {
+			e.notifyProtocolGoroutine(notifyReset)
+		} else {
+			e.notifyProtocolGoroutine(notifyTickleWorker | notifyResync)
+		}
 		if epState == StateFinWait2 && closed {
 			// If the endpoint has been closed then make sure we notify so
 			// that the FIN_WAIT2 timer is started after a restore.
@@ -292,6 +297,16 @@ func (e *endpoint) Resume(s *stack.Stack) {
 	}
 }
 
+// suspendedTooLong returns true if the endpoint was saved longer ago than the
+// stack's restore window allows. See tcpip.TCPRestoreWindowOption.
+func (e *endpoint) suspendedTooLong() bool {
+	var rw tcpip.TCPRestoreWindowOption
+	if err := e.stack.TransportProtocolOption(ProtocolNumber, &rw); err != nil || rw == 0 || e.saveTime.IsZero() {
+		return false
+	}
+	return time.Since(e.saveTime) > time.Duration(rw)
+}
+
 // saveLastError is invoked by stateify.
 func (e *endpoint) saveLastError() string {
 	if e.lastError == nil {
@@ -310,6 +325,16 @@ func (e *endpoint) loadLastError(s string) {
 	e.lastError = tcpip.StringToError(s)
 }
 
+// saveSaveTime is invoked by stateify.
+func (e *endpoint) saveSaveTime() unixTime {
+	return unixTime{e.saveTime.Unix(), int64(e.saveTime.Nanosecond())}
+}
+
+// loadSaveTime is invoked by stateify.
+func (e *endpoint) loadSaveTime(unix unixTime) {
+	e.saveTime = time.Unix(unix.second, unix.nano)
+}
+
 // saveRecentTSTime is invoked by stateify.
 func (e *endpoint) saveRecentTSTime() unixTime {
 	return unixTime{e.recentTSTime.Unix(), e.recentTSTime.UnixNano()}
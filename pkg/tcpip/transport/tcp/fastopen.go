@@ -0,0 +1,259 @@
+// Copyright 2021 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tcp
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"io"
+
+	"gvisor.dev/gvisor/pkg/rand"
+	"gvisor.dev/gvisor/pkg/sync"
+	"gvisor.dev/gvisor/pkg/tcpip"
+	"gvisor.dev/gvisor/pkg/tcpip/buffer"
+	"gvisor.dev/gvisor/pkg/tcpip/header"
+	"gvisor.dev/gvisor/pkg/tcpip/seqnum"
+	"gvisor.dev/gvisor/pkg/tcpip/stack"
+)
+
+const (
+	// fastOpenCookieLen is the length of the TCP Fast Open cookies handed
+	// out by listening endpoints. This matches Linux.
+	fastOpenCookieLen = 8
+
+	// fastOpenCacheSize is the maximum number of client cookies that are
+	// remembered by the stack.
+	fastOpenCacheSize = 1024
+)
+
+// fastOpenState holds the stack wide TCP Fast Open (RFC 7413) state: the key
+// used to generate server cookies and the cache of cookies received from
+// servers.
+type fastOpenState struct {
+	// key is the secret used to generate server cookies. It is immutable
+	// after initialization.
+	key [16]byte
+
+	mu sync.Mutex
+	// cookies maps a remote address to the cookie last received from it.
+	cookies map[tcpip.Address][]byte
+}
+
+func (f *fastOpenState) init() {
+	rand.Read(f.key[:])
+	f.cookies = make(map[tcpip.Address][]byte)
+}
+
+// serverCookie returns the cookie a listening endpoint hands out to the
+// client of the connection identified by id.
+func (f *fastOpenState) serverCookie(id stack.TransportEndpointID) []byte {
+	h := sha1.New()
+	h.Write(f.key[:])
+	io.WriteString(h, string(id.LocalAddress))
+	io.WriteString(h, string(id.RemoteAddress))
+	return h.Sum(nil)[:fastOpenCookieLen]
+}
+
+// cachedCookie returns the cookie cached for addr, if any.
+func (f *fastOpenState) cachedCookie(addr tcpip.Address) ([]byte, bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	c, ok := f.cookies[addr]
+	return c, ok
+}
+
+// cacheCookie remembers the cookie received from addr. An empty cookie
+// removes any cached cookie.
+func (f *fastOpenState) cacheCookie(addr tcpip.Address, cookie []byte) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if len(cookie) == 0 {
+		delete(f.cookies, addr)
+		return
+	}
+	if _, ok := f.cookies[addr]; !ok && len(f.cookies) >= fastOpenCacheSize {
+		// Evict an arbitrary entry to make room.
+		for a := range f.cookies {
+			delete(f.cookies, a)
+			break
+		}
+	}
+	f.cookies[addr] = append([]byte(nil), cookie...)
+}
+
+// fastOpen returns the stack wide TCP Fast Open state.
+func (e *endpoint) fastOpen() *fastOpenState {
+	return &e.stack.TransportProtocolInstance(ProtocolNumber).(*protocol).fastOpen
+}
+
+// handleFastOpenSyn inspects the TCP Fast Open option of a SYN received by a
+// listening endpoint and updates the passive handshake h accordingly. A
+// cookie request or an invalid cookie is answered with a fresh cookie in the
+// SYN-ACK. A valid cookie allows the data in the SYN to be acknowledged right
+// away; it is delivered to the new endpoint once the handshake completes.
+//
+// Precondition: e.mu must be held and h must not have been started.
+func (e *endpoint) handleFastOpenSyn(h *handshake, s *segment, opts *header.TCPSynOptions) {
+	// There is no room for the Fast Open option next to a TCP MD5
+	// signature.
+	if e.fastOpenQueueLen == 0 || !opts.FastOpen || e.md5Key(s.id.RemoteAddress) != nil {
+		return
+	}
+	cookie := e.fastOpen().serverCookie(s.id)
+	if !bytes.Equal(opts.FastOpenCookie, cookie) {
+		h.fastOpen = true
+		h.fastOpenCookie = cookie
+		return
+	}
+	if s.data.Size() == 0 || e.fastOpenPending >= e.fastOpenQueueLen {
+		return
+	}
+	e.fastOpenPending++
+	h.fastOpenListener = e
+
+	d := s.clone()
+	d.ep = nil
+	d.flags = header.TCPFlagAck
+	d.sequenceNumber = s.sequenceNumber + 1
+	h.fastOpenRcvd = d
+	h.ackNum = h.ackNum.Add(seqnum.Size(d.data.Size()))
+}
+
+// fastOpenDone releases the Fast Open queue slot used by h, if any.
+//
+// Precondition: the listening endpoint's mu must be held.
+func (h *handshake) fastOpenDone() {
+	if l := h.fastOpenListener; l != nil {
+		l.fastOpenPending--
+		h.fastOpenListener = nil
+	}
+}
+
+// connectFastOpen handles the connect(2) part of a sendto(2) with
+// MSG_FASTOPEN or of a connect(2) with TCP_FASTOPEN_CONNECT set. If a cookie
+// for addr is cached, the endpoint is connected without starting the
+// handshake; the SYN is sent along with the first write. Otherwise the
+// handshake is started with a cookie request.
+//
+// It returns tcpip.ErrConnectStarted if the handshake was started.
+func (e *endpoint) connectFastOpen(addr tcpip.FullAddress) *tcpip.Error {
+	e.LockUser()
+	peer, _, err := e.checkV4MappedLocked(addr)
+	e.UnlockUser()
+	if err != nil {
+		return err
+	}
+	if e.md5Key(peer.Addr) != nil {
+		// Fast Open is not used along with TCP MD5 signatures, see
+		// handleFastOpenSyn.
+		return e.connect(addr, true, true)
+	}
+	if cookie, ok := e.fastOpen().cachedCookie(peer.Addr); ok {
+		if err := e.connect(addr, true, false); err != tcpip.ErrConnectStarted {
+			return err
+		}
+		e.LockUser()
+		e.fastOpenDeferred = true
+		e.fastOpenCookie = cookie
+		e.UnlockUser()
+		return nil
+	}
+	e.LockUser()
+	e.fastOpenRequest = true
+	e.UnlockUser()
+	return e.connect(addr, true, true)
+}
+
+// startFastOpenLocked starts the handshake of a connection whose SYN was
+// deferred by connectFastOpen, carrying the data in the first queued segment
+// along with the cached cookie.
+//
+// Precondition: e.mu and e.sndBufMu must be held.
+func (e *endpoint) startFastOpenLocked() {
+	e.fastOpenDeferred = false
+
+	h := e.newHandshake()
+	h.fastOpen = true
+	h.fastOpenCookie = e.fastOpenCookie
+	if s := e.sndQueue.Front(); s != nil {
+		// Leave room for the TCP options in the SYN.
+		max := int(calculateAdvertisedMSS(e.userMSS, e.route)) - header.TCPOptionsMaximumSize
+		if max < 0 {
+			max = 0
+		}
+		v := s.data.ToView()
+		if len(v) > max {
+			v = v[:max]
+		}
+		h.fastOpenData = v
+	}
+	e.setEndpointState(StateSynSent)
+	h.start()
+
+	e.stack.Stats().TCP.ActiveConnectionOpenings.Increment()
+	e.workerRunning = true
+	// Make sure the queued data is handed to the sender once the
+	// handshake completes.
+	e.sndWaker.Assert()
+	go e.protocolMainLoop(true, nil) // S/R-SAFE: will be drained before save.
+}
+
+// fastOpenSynAcked is called by an active handshake on receipt of the SYN-ACK.
+// It updates the cookie cache and returns the number of bytes of SYN data
+// that the peer acknowledged.
+func (h *handshake) fastOpenSynAcked(s *segment, opts *header.TCPSynOptions) seqnum.Size {
+	if !h.fastOpen {
+		return 0
+	}
+	acked := (h.iss + 1).Size(s.ackNumber)
+	switch {
+	case opts.FastOpen && len(opts.FastOpenCookie) != 0:
+		h.ep.fastOpen().cacheCookie(h.ep.ID.RemoteAddress, opts.FastOpenCookie)
+	case acked == 0 && len(h.fastOpenData) != 0:
+		// The peer ignored both our cookie and our data, stop using
+		// the cookie.
+		h.ep.fastOpen().cacheCookie(h.ep.ID.RemoteAddress, nil)
+	}
+	return acked
+}
+
+// trimFastOpenDataLocked removes the n bytes of SYN data acknowledged by the
+// peer from the front of the send queue.
+//
+// Precondition: e.mu must be held.
+func (e *endpoint) trimFastOpenDataLocked(n seqnum.Size) {
+	e.sndBufMu.Lock()
+	if s := e.sndQueue.Front(); s != nil {
+		s.data.TrimFront(int(n))
+		e.sndBufInQueue -= n
+		if s.data.Size() == 0 {
+			e.sndQueue.Remove(s)
+			s.decRef()
+		}
+	}
+	e.sndBufMu.Unlock()
+	e.updateSndBufferUsage(int(n))
+}
+
+// sendSynDataTCP is like sendSynTCP, but the SYN also carries data.
+func (e *endpoint) sendSynDataTCP(r *stack.Route, tf tcpFields, opts header.TCPSynOptions, data buffer.View) *tcpip.Error {
+	tf.opts = makeSynOptions(opts)
+	// We ignore SYN send errors and let the callers re-attempt send.
+	if err := e.sendTCP(r, tf, data.ToVectorisedView(), nil); err != nil {
+		e.stats.SendErrors.SynSendToNetworkFailed.Increment()
+	}
+	putOptions(tf.opts)
+	return nil
+}
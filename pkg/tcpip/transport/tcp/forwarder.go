@@ -69,7 +69,7 @@ func (f *Forwarder) HandlePacket(id stack.TransportEndpointID, pkt *stack.Packet
 	defer s.decRef()
 
 	// We only care about well-formed SYN packets.
-	if !s.parse(pkt.RXTransportChecksumValidated) || !s.csumValid || s.flags != header.TCPFlagSyn {
+	if !s.parse(pkt.RXTransportChecksumValidated) || !s.csumValid || s.flags&^ecnFlags != header.TCPFlagSyn {
 		return false
 	}
 
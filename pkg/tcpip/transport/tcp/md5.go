@@ -0,0 +1,174 @@
+// Copyright 2021 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tcp
+
+import (
+	"crypto/subtle"
+
+	"gvisor.dev/gvisor/pkg/tcpip"
+	"gvisor.dev/gvisor/pkg/tcpip/buffer"
+	"gvisor.dev/gvisor/pkg/tcpip/header"
+	"gvisor.dev/gvisor/pkg/tcpip/stack"
+)
+
+// md5OptionSize is the space taken by the TCP MD5 signature option in a
+// segment, including the two NOPs that align it.
+const md5OptionSize = 2 + header.TCPOptionMD5Length
+
+// md5Key is a TCP MD5 signature key (RFC 2385) used for the peers whose
+// address matches addr in its first prefixLen bits.
+//
+// +stateify savable
+type md5Key struct {
+	addr      tcpip.Address
+	prefixLen int
+	key       []byte
+}
+
+// matches returns true if k applies to the peer at addr.
+func (k *md5Key) matches(addr tcpip.Address) bool {
+	if len(addr) != len(k.addr) {
+		return false
+	}
+	subnet := tcpip.AddressWithPrefix{Address: k.addr, PrefixLen: k.prefixLen}.Subnet()
+	return subnet.Contains(addr)
+}
+
+// setMD5Key installs or, if key is empty, removes the TCP MD5 signature key
+// for the peers in addr/prefixLen.
+func (e *endpoint) setMD5Key(addr tcpip.Address, prefixLen int, key []byte) *tcpip.Error {
+	if prefixLen < 0 || prefixLen > len(addr)*8 || len(key) > tcpip.TCPMD5SigMaxKeyLen {
+		return tcpip.ErrInvalidOptionValue
+	}
+	// Only the first prefixLen bits of the address are significant.
+	subnet := tcpip.AddressWithPrefix{Address: addr, PrefixLen: prefixLen}.Subnet()
+	addr = subnet.ID()
+
+	e.md5Mu.Lock()
+	defer e.md5Mu.Unlock()
+	for i := range e.md5Keys {
+		k := &e.md5Keys[i]
+		if k.addr != addr || k.prefixLen != prefixLen {
+			continue
+		}
+		if len(key) == 0 {
+			e.md5Keys = append(e.md5Keys[:i], e.md5Keys[i+1:]...)
+		} else {
+			k.key = append([]byte(nil), key...)
+		}
+		return nil
+	}
+	if len(key) == 0 {
+		return tcpip.ErrNoSuchFile
+	}
+	e.md5Keys = append(e.md5Keys, md5Key{
+		addr:      addr,
+		prefixLen: prefixLen,
+		key:       append([]byte(nil), key...),
+	})
+	return nil
+}
+
+// md5Key returns the TCP MD5 signature key to use with the peer at addr, or
+// nil if there is none. The key with the longest matching prefix wins.
+func (e *endpoint) md5Key(addr tcpip.Address) []byte {
+	e.md5Mu.RLock()
+	defer e.md5Mu.RUnlock()
+	var best *md5Key
+	for i := range e.md5Keys {
+		k := &e.md5Keys[i]
+		if k.matches(addr) && (best == nil || k.prefixLen > best.prefixLen) {
+			best = k
+		}
+	}
+	if best == nil {
+		return nil
+	}
+	return best.key
+}
+
+// inheritMD5KeyLocked copies the key that the listening endpoint e uses for
+// the peer of n, if any, to the newly created endpoint n.
+//
+// Precondition: e.mu and n.mu must be held.
+func (e *endpoint) inheritMD5KeyLocked(n *endpoint) {
+	key := e.md5Key(n.ID.RemoteAddress)
+	if key == nil {
+		return
+	}
+	n.md5Mu.Lock()
+	n.md5Keys = []md5Key{{
+		addr:      n.ID.RemoteAddress,
+		prefixLen: len(n.ID.RemoteAddress) * 8,
+		key:       key,
+	}}
+	n.md5Mu.Unlock()
+	n.disableHardwareGSOForMD5Locked()
+}
+
+// disableHardwareGSOForMD5Locked stops using hardware GSO if segments to the
+// peer must be signed, as each segment needs its own signature.
+//
+// Precondition: e.mu must be held.
+func (e *endpoint) disableHardwareGSOForMD5Locked() {
+	if e.gso != nil && e.gso.Type != stack.GSOSW && e.md5Key(e.ID.RemoteAddress) != nil {
+		e.gso = nil
+	}
+}
+
+// checkMD5 verifies the TCP MD5 signature of a segment received by e, as
+// described in RFC 2385, section 3.0. Segments from a peer with a key must
+// carry a valid signature and segments from other peers must carry none.
+func (e *endpoint) checkMD5(s *segment) bool {
+	key := e.md5Key(s.srcAddr)
+	digest := header.ParseMD5Option(s.options)
+	switch {
+	case key == nil && digest == nil:
+		return true
+	case key == nil || digest == nil:
+		return false
+	}
+	want := header.TCPMD5Digest(s.srcAddr, s.dstAddr, s.hdr, s.data, key)
+	return subtle.ConstantTimeCompare(digest, want[:]) == 1
+}
+
+// addMD5Option returns tf with a placeholder TCP MD5 signature option
+// prepended to its options if segments to the peer must be signed. The
+// returned options must be released with putOptions if they differ from the
+// original ones.
+func (e *endpoint) addMD5Option(tf tcpFields) tcpFields {
+	key := e.md5Key(tf.id.RemoteAddress)
+	if key == nil || len(tf.opts)+md5OptionSize > maxOptionSize {
+		// The latter only happens if the key was installed after the
+		// options were built, in which case the peer will drop the
+		// segment as if it was lost.
+		return tf
+	}
+	options := getOptions()
+	offset := header.EncodeNOP(options)
+	offset += header.EncodeNOP(options[offset:])
+	offset += header.EncodeMD5Option(options[offset:])
+	offset += copy(options[offset:], tf.opts)
+	tf.opts = options[:offset]
+	tf.md5Key = key
+	return tf
+}
+
+// signTCP fills in the TCP MD5 signature of a segment built by buildTCPHdr
+// with options from addMD5Option.
+func signTCP(r *stack.Route, tcp header.TCP, data buffer.VectorisedView, key []byte) {
+	digest := header.TCPMD5Digest(r.LocalAddress, r.RemoteAddress, tcp, data, key)
+	copy(tcp[header.TCPMinimumSize+2+2:], digest[:])
+}
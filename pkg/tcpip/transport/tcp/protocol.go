@@ -147,12 +147,19 @@ type protocol struct {
 	lingerTimeout              time.Duration
 	timeWaitTimeout            time.Duration
 	timeWaitReuse              tcpip.TCPTimeWaitReuseOption
+	restoreWindow              time.Duration
 	minRTO                     time.Duration
 	maxRTO                     time.Duration
 	maxRetries                 uint32
 	synRcvdCount               synRcvdCounter
 	synRetries                 uint8
 	dispatcher                 dispatcher
+	fastOpen                   fastOpenState
+	ecn                        tcpip.TCPECNOption
+	ecnFallback                bool
+	synCookies                 tcpip.TCPSynCookiesOption
+	abortOnOverflow            bool
+	migrateReq                 bool
 }
 
 // Number returns the tcp protocol number.
@@ -338,6 +345,16 @@ func (p *protocol) SetOption(option tcpip.SettableTransportProtocolOption) *tcpi
 		p.mu.Unlock()
 		return nil
 
+	case *tcpip.TCPRestoreWindowOption:
+		p.mu.Lock()
+		if *v < 0 {
+			p.restoreWindow = 0
+		} else {
+			p.restoreWindow = time.Duration(*v)
+		}
+		p.mu.Unlock()
+		return nil
+
 	case *tcpip.TCPTimeWaitReuseOption:
 		if *v < tcpip.TCPTimeWaitReuseDisabled || *v > tcpip.TCPTimeWaitReuseLoopbackOnly {
 			return tcpip.ErrInvalidOptionValue
@@ -388,6 +405,42 @@ func (p *protocol) SetOption(option tcpip.SettableTransportProtocolOption) *tcpi
 		p.mu.Unlock()
 		return nil
 
+	case *tcpip.TCPECNOption:
+		if *v < tcpip.TCPECNDisabled || *v > tcpip.TCPAccECNPassive {
+			return tcpip.ErrInvalidOptionValue
+		}
+		p.mu.Lock()
+		p.ecn = *v
+		p.mu.Unlock()
+		return nil
+
+	case *tcpip.TCPECNFallbackOption:
+		p.mu.Lock()
+		p.ecnFallback = bool(*v)
+		p.mu.Unlock()
+		return nil
+
+	case *tcpip.TCPSynCookiesOption:
+		if *v < tcpip.TCPSynCookiesDisabled || *v > tcpip.TCPSynCookiesAlways {
+			return tcpip.ErrInvalidOptionValue
+		}
+		p.mu.Lock()
+		p.synCookies = *v
+		p.mu.Unlock()
+		return nil
+
+	case *tcpip.TCPAbortOnOverflowOption:
+		p.mu.Lock()
+		p.abortOnOverflow = bool(*v)
+		p.mu.Unlock()
+		return nil
+
+	case *tcpip.TCPMigrateReqOption:
+		p.mu.Lock()
+		p.migrateReq = bool(*v)
+		p.mu.Unlock()
+		return nil
+
 	default:
 		return tcpip.ErrUnknownProtocolOption
 	}
@@ -456,6 +509,12 @@ func (p *protocol) Option(option tcpip.GettableTransportProtocolOption) *tcpip.E
 		p.mu.RUnlock()
 		return nil
 
+	case *tcpip.TCPRestoreWindowOption:
+		p.mu.RLock()
+		*v = tcpip.TCPRestoreWindowOption(p.restoreWindow)
+		p.mu.RUnlock()
+		return nil
+
 	case *tcpip.TCPTimeWaitReuseOption:
 		p.mu.RLock()
 		*v = tcpip.TCPTimeWaitReuseOption(p.timeWaitReuse)
@@ -492,6 +551,36 @@ func (p *protocol) Option(option tcpip.GettableTransportProtocolOption) *tcpip.E
 		p.mu.RUnlock()
 		return nil
 
+	case *tcpip.TCPECNOption:
+		p.mu.RLock()
+		*v = p.ecn
+		p.mu.RUnlock()
+		return nil
+
+	case *tcpip.TCPECNFallbackOption:
+		p.mu.RLock()
+		*v = tcpip.TCPECNFallbackOption(p.ecnFallback)
+		p.mu.RUnlock()
+		return nil
+
+	case *tcpip.TCPSynCookiesOption:
+		p.mu.RLock()
+		*v = p.synCookies
+		p.mu.RUnlock()
+		return nil
+
+	case *tcpip.TCPAbortOnOverflowOption:
+		p.mu.RLock()
+		*v = tcpip.TCPAbortOnOverflowOption(p.abortOnOverflow)
+		p.mu.RUnlock()
+		return nil
+
+	case *tcpip.TCPMigrateReqOption:
+		p.mu.RLock()
+		*v = tcpip.TCPMigrateReqOption(p.migrateReq)
+		p.mu.RUnlock()
+		return nil
+
 	default:
 		return tcpip.ErrUnknownProtocolOption
 	}
@@ -542,9 +631,13 @@ func NewProtocol(s *stack.Stack) stack.TransportProtocol {
 		minRTO:                     MinRTO,
 		maxRTO:                     MaxRTO,
 		maxRetries:                 MaxRetries,
+		ecn:                        tcpip.TCPECNPassive,
+		ecnFallback:                true,
+		synCookies:                 tcpip.TCPSynCookiesOnOverflow,
 		// TODO(gvisor.dev/issue/5243): Set recovery to tcpip.TCPRACKLossDetection.
 		recovery: 0,
 	}
 	p.dispatcher.init(runtime.GOMAXPROCS(0))
+	p.fastOpen.init()
 	return &p
 }
@@ -69,6 +69,13 @@ type segment struct {
 	// csumValid is true if the csum in the received segment is valid.
 	csumValid bool
 
+	// ecn is the ECN codepoint of the IP header that carried a received
+	// segment.
+	ecn uint8
+
+	// ae is the AE (Accurate ECN) flag of a received segment.
+	ae bool
+
 	// parsedOptions stores the parsed values from the options in the segment.
 	parsedOptions  header.TCPOptions
 	options        []byte `state:".([]byte)"`
@@ -95,6 +102,8 @@ func newIncomingSegment(id stack.TransportEndpointID, pkt *stack.PacketBuffer) *
 		netProto: pkt.NetworkProtocolNumber,
 		nicID:    pkt.NICID,
 	}
+	tos, _ := netHdr.TOS()
+	s.ecn = tos & header.ECNMask
 	s.data = pkt.Data.Clone(s.views[:])
 	s.hdr = header.TCP(pkt.TransportHeader().View())
 	s.rcvdTime = time.Now()
@@ -250,6 +259,7 @@ func (s *segment) parse(skipChecksumValidation bool) bool {
 	s.sequenceNumber = seqnum.Value(s.hdr.SequenceNumber())
 	s.ackNumber = seqnum.Value(s.hdr.AckNumber())
 	s.flags = s.hdr.Flags()
+	s.ae = s.hdr.AE()
 	s.window = seqnum.Size(s.hdr.WindowSize())
 	return true
 }
@@ -429,7 +429,18 @@ func (s *sender) updateMaxPayloadSize(mtu, count int) {
 
 // sendAck sends an ACK segment.
 func (s *sender) sendAck() {
-	s.sendSegmentFromView(buffer.VectorisedView{}, header.TCPFlagAck, s.sndNxt)
+	s.sendSegmentFromView(buffer.VectorisedView{}, header.TCPFlagAck, s.sndNxt, false /* ect */)
+}
+
+// resync resynchronizes the connection with the peer after it was suspended
+// by save and restore. An ACK tells the peer where the connection stands, and
+// elicits an ACK with the peer's current window in return. The retransmit
+// timer isn't saved, so it's restarted if there is unacknowledged data.
+func (s *sender) resync() {
+	s.sendAck()
+	if s.sndUna != s.sndNxt {
+		s.resendTimer.enable(s.rto)
+	}
 }
 
 // updateRTO updates the retransmit timeout when a new roud-trip time is
@@ -1389,6 +1400,9 @@ func (s *sender) handleRcvdSegment(rcvdSeg *segment) {
 	if (ack - 1).InRange(s.sndUna, s.sndNxt) {
 		s.dupAckCount = 0
 
+		// Respond to any congestion signalled through ECN.
+		s.handleECNFeedback(rcvdSeg)
+
 		// See : https://tools.ietf.org/html/rfc1323#section-3.3.
 		// Specifically we should only update the RTO using TSEcr if the
 		// following condition holds:
@@ -1518,7 +1532,10 @@ func (s *sender) sendSegment(seg *segment) *tcpip.Error {
 	}
 	seg.xmitTime = time.Now()
 	seg.xmitCount++
-	err := s.sendSegmentFromView(seg.data, seg.flags, seg.sequenceNumber)
+	// Retransmitted segments are not sent as ECN-capable, see RFC 3168
+	// section 6.1.5.
+	ect := seg.xmitCount == 1 && seg.data.Size() != 0
+	err := s.sendSegmentFromView(seg.data, seg.flags, seg.sequenceNumber, ect)
 
 	// Every time a packet containing data is sent (including a
 	// retransmission), if SACK is enabled and we are retransmitting data
@@ -1538,8 +1555,9 @@ func (s *sender) sendSegment(seg *segment) *tcpip.Error {
 }
 
 // sendSegmentFromView sends a new segment containing the given payload, flags
-// and sequence number.
-func (s *sender) sendSegmentFromView(data buffer.VectorisedView, flags byte, seq seqnum.Value) *tcpip.Error {
+// and sequence number. The segment is sent as ECN-capable if ect is true and
+// ECN is in use.
+func (s *sender) sendSegmentFromView(data buffer.VectorisedView, flags byte, seq seqnum.Value, ect bool) *tcpip.Error {
 	s.lastSendTime = time.Now()
 	if seq == s.rttMeasureSeqNum {
 		s.rttMeasureTime = s.lastSendTime
@@ -1550,5 +1568,5 @@ func (s *sender) sendSegmentFromView(data buffer.VectorisedView, flags byte, seq
 	// Remember the max sent ack.
 	s.maxSentAck = rcvNxt
 
-	return s.ep.sendRaw(data, flags, seq, rcvNxt, rcvWnd)
+	return s.ep.sendRawECT(data, flags, seq, rcvNxt, rcvWnd, ect)
 }
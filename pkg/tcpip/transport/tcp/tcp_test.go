@@ -16,6 +16,7 @@ package tcp_test
 
 import (
 	"bytes"
+	gocontext "context"
 	"fmt"
 	"io/ioutil"
 	"math"
@@ -25,8 +26,10 @@ import (
 
 	"github.com/google/go-cmp/cmp"
 	"gvisor.dev/gvisor/pkg/rand"
+	"gvisor.dev/gvisor/pkg/state"
 	"gvisor.dev/gvisor/pkg/sync"
 	"gvisor.dev/gvisor/pkg/tcpip"
+	"gvisor.dev/gvisor/pkg/tcpip/buffer"
 	"gvisor.dev/gvisor/pkg/tcpip/checker"
 	"gvisor.dev/gvisor/pkg/tcpip/header"
 	"gvisor.dev/gvisor/pkg/tcpip/link/loopback"
@@ -2952,6 +2955,94 @@ func TestSendGreaterThanMTU(t *testing.T) {
 	testBrokenUpWrite(t, c, maxPayload)
 }
 
+func TestECNNegotiation(t *testing.T) {
+	c := context.New(t, defaultMTU)
+	defer c.Cleanup()
+
+	opt := tcpip.TCPECNEnabled
+	if err := c.Stack().SetTransportProtocolOption(tcp.ProtocolNumber, &opt); err != nil {
+		t.Fatalf("SetTransportProtocolOption(%d, &%T(%d)): %s", tcp.ProtocolNumber, opt, opt, err)
+	}
+
+	c.Create(-1 /* epRcvBuf */)
+	if err := c.EP.Connect(tcpip.FullAddress{Addr: context.TestAddr, Port: context.TestPort}); err != tcpip.ErrConnectStarted {
+		t.Fatalf("got c.EP.Connect(...) = %s, want = %s", err, tcpip.ErrConnectStarted)
+	}
+
+	// The SYN must request ECN.
+	b := c.GetPacket()
+	checker.IPv4(t, b,
+		checker.TOS(header.ECNNotECT, 0),
+		checker.TCP(
+			checker.DstPort(context.TestPort),
+			checker.TCPFlags(header.TCPFlagSyn|header.TCPFlagEce|header.TCPFlagCwr),
+		),
+	)
+	tcpHdr := header.TCP(header.IPv4(b).Payload())
+	c.IRS = seqnum.Value(tcpHdr.SequenceNumber())
+	c.Port = tcpHdr.SourcePort()
+
+	const iss = 789
+	c.SendPacket(nil, &context.Headers{
+		SrcPort: context.TestPort,
+		DstPort: c.Port,
+		Flags:   header.TCPFlagSyn | header.TCPFlagAck | header.TCPFlagEce,
+		SeqNum:  iss,
+		AckNum:  c.IRS.Add(1),
+		RcvWnd:  30000,
+	})
+	checker.IPv4(t, c.GetPacket(),
+		checker.TOS(header.ECNNotECT, 0),
+		checker.TCP(
+			checker.DstPort(context.TestPort),
+			checker.TCPFlags(header.TCPFlagAck),
+			checker.TCPSeqNum(uint32(c.IRS)+1),
+			checker.TCPAckNum(iss+1),
+		),
+	)
+
+	// New data must be sent as ECN-capable.
+	var r bytes.Reader
+	r.Reset([]byte{1, 2, 3})
+	if _, err := c.EP.Write(&r, tcpip.WriteOptions{}); err != nil {
+		t.Fatalf("Write failed: %s", err)
+	}
+	checker.IPv4(t, c.GetPacket(),
+		checker.TOS(header.ECNECT0, 0),
+		checker.TCP(
+			checker.DstPort(context.TestPort),
+			checker.TCPFlagsMatch(header.TCPFlagAck, ^uint8(header.TCPFlagPsh)),
+			checker.TCPSeqNum(uint32(c.IRS)+1),
+		),
+	)
+
+	// An ECE from the peer reduces the congestion window, which is signalled
+	// with CWR on the next new data segment.
+	c.SendPacket(nil, &context.Headers{
+		SrcPort: context.TestPort,
+		DstPort: c.Port,
+		Flags:   header.TCPFlagAck | header.TCPFlagEce,
+		SeqNum:  iss + 1,
+		AckNum:  c.IRS.Add(4),
+		RcvWnd:  30000,
+	})
+	r.Reset([]byte{4, 5, 6})
+	if _, err := c.EP.Write(&r, tcpip.WriteOptions{}); err != nil {
+		t.Fatalf("Write failed: %s", err)
+	}
+	checker.IPv4(t, c.GetPacket(),
+		checker.TOS(header.ECNECT0, 0),
+		checker.TCP(
+			checker.DstPort(context.TestPort),
+			checker.TCPFlagsMatch(header.TCPFlagAck|header.TCPFlagCwr, ^uint8(header.TCPFlagPsh)),
+			checker.TCPSeqNum(uint32(c.IRS)+4),
+		),
+	)
+	if got := c.Stack().Stats().TCP.ECNCongestionEvents.Value(); got != 1 {
+		t.Errorf("got stats.TCP.ECNCongestionEvents.Value() = %d, want = 1", got)
+	}
+}
+
 func TestSetTTL(t *testing.T) {
 	for _, wantTTL := range []uint8{1, 2, 50, 64, 128, 254, 255} {
 		t.Run(fmt.Sprintf("TTL:%d", wantTTL), func(t *testing.T) {
@@ -4890,6 +4981,89 @@ func TestTCPEndpointProbe(t *testing.T) {
 	}
 }
 
+func TestStackSetRestoreWindow(t *testing.T) {
+	for _, tc := range []struct {
+		set  tcpip.TCPRestoreWindowOption
+		want tcpip.TCPRestoreWindowOption
+	}{
+		{set: tcpip.TCPRestoreWindowOption(30 * time.Second), want: tcpip.TCPRestoreWindowOption(30 * time.Second)},
+		{set: 0, want: 0},
+		{set: -1, want: 0},
+	} {
+		t.Run(fmt.Sprintf("SetTransportProtocolOption(.., %v)", time.Duration(tc.set)), func(t *testing.T) {
+			c := context.New(t, 1500)
+			defer c.Cleanup()
+
+			s := c.Stack()
+			if err := s.SetTransportProtocolOption(tcp.ProtocolNumber, &tc.set); err != nil {
+				t.Fatalf("s.SetTransportProtocolOption(%d, &%T(%d)) = %s", tcp.ProtocolNumber, tc.set, tc.set, err)
+			}
+			var got tcpip.TCPRestoreWindowOption
+			if err := s.TransportProtocolOption(tcp.ProtocolNumber, &got); err != nil {
+				t.Fatalf("s.TransportProtocolOption(%d, &%T) = %s", tcp.ProtocolNumber, got, err)
+			}
+			if got != tc.want {
+				t.Errorf("got restore window = %d, want = %d", got, tc.want)
+			}
+		})
+	}
+}
+
+// TestRestoreEstablished saves a connected endpoint and restores it into a
+// new stack, which resynchronizes the connection if the endpoint was restored
+// within the restore window and resets it otherwise.
+func TestRestoreEstablished(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		window tcpip.TCPRestoreWindowOption
+		flags  uint8
+	}{
+		{name: "within window", window: tcpip.TCPRestoreWindowOption(time.Hour), flags: header.TCPFlagAck},
+		{name: "beyond window", window: tcpip.TCPRestoreWindowOption(time.Nanosecond), flags: header.TCPFlagAck | header.TCPFlagRst},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			c := context.New(t, defaultMTU)
+			defer c.Cleanup()
+
+			c.SetSaveRestoreEnabled(true)
+			c.CreateConnected(789, 30000, -1 /* epRcvBuf */)
+
+			var buf bytes.Buffer
+			if _, err := state.Save(gocontext.Background(), &buf, &c.EP); err != nil {
+				t.Fatalf("state.Save: %v", err)
+			}
+
+			// Restore into a stack with the same addresses, as runsc does.
+			c2 := context.New(t, defaultMTU)
+			defer c2.Cleanup()
+			s := c2.Stack()
+			if err := s.SetTransportProtocolOption(tcp.ProtocolNumber, &tc.window); err != nil {
+				t.Fatalf("s.SetTransportProtocolOption(%d, &%T(%d)) = %s", tcp.ProtocolNumber, tc.window, tc.window, err)
+			}
+			// Make sure the endpoint has been suspended for longer than
+			// the shorter window.
+			time.Sleep(time.Millisecond)
+
+			origStack := stack.StackFromEnv
+			stack.StackFromEnv = s
+			defer func() { stack.StackFromEnv = origStack }()
+			if _, err := state.Load(gocontext.Background(), &buf, &c2.EP); err != nil {
+				t.Fatalf("state.Load: %v", err)
+			}
+			s.Resume()
+			tcpip.AsyncLoading.Wait()
+
+			checker.IPv4(t, c2.GetPacket(),
+				checker.TCP(
+					checker.DstPort(context.TestPort),
+					checker.TCPAckNum(790),
+					checker.TCPFlags(tc.flags),
+				),
+			)
+		})
+	}
+}
+
 func TestStackSetCongestionControl(t *testing.T) {
 	testCases := []struct {
 		cc  tcpip.CongestionControlOption
@@ -5779,6 +5953,261 @@ func TestListenBacklogFullSynCookieInUse(t *testing.T) {
 	}
 }
 
+func TestSynCookiesDisabled(t *testing.T) {
+	c := context.New(t, defaultMTU)
+	defer c.Cleanup()
+
+	// Any SYN would be answered with a SYN cookie if they were enabled.
+	threshold := tcpip.TCPSynRcvdCountThresholdOption(0)
+	if err := c.Stack().SetTransportProtocolOption(tcp.ProtocolNumber, &threshold); err != nil {
+		t.Fatalf("SetTransportProtocolOption(%d, &%T(%d)): %s", tcp.ProtocolNumber, threshold, threshold, err)
+	}
+	opt := tcpip.TCPSynCookiesDisabled
+	if err := c.Stack().SetTransportProtocolOption(tcp.ProtocolNumber, &opt); err != nil {
+		t.Fatalf("SetTransportProtocolOption(%d, &%T(%d)): %s", tcp.ProtocolNumber, opt, opt, err)
+	}
+
+	var err *tcpip.Error
+	c.EP, err = c.Stack().NewEndpoint(tcp.ProtocolNumber, ipv4.ProtocolNumber, &c.WQ)
+	if err != nil {
+		t.Fatalf("NewEndpoint failed: %s", err)
+	}
+	if err := c.EP.Bind(tcpip.FullAddress{Port: context.StackPort}); err != nil {
+		t.Fatalf("Bind failed: %s", err)
+	}
+	if err := c.EP.Listen(10); err != nil {
+		t.Fatalf("Listen failed: %s", err)
+	}
+
+	c.SendPacket(nil, &context.Headers{
+		SrcPort: context.TestPort,
+		DstPort: context.StackPort,
+		Flags:   header.TCPFlagSyn,
+		SeqNum:  789,
+		RcvWnd:  30000,
+	})
+	c.CheckNoPacketTimeout("unexpected packet received", 50*time.Millisecond)
+
+	if got := c.Stack().Stats().TCP.ListenOverflowSynDrop.Value(); got != 1 {
+		t.Errorf("got stats.TCP.ListenOverflowSynDrop.Value() = %d, want = 1", got)
+	}
+	if got := c.Stack().Stats().TCP.ListenOverflowSynCookieSent.Value(); got != 0 {
+		t.Errorf("got stats.TCP.ListenOverflowSynCookieSent.Value() = %d, want = 0", got)
+	}
+}
+
+func TestSynCookiesAlways(t *testing.T) {
+	c := context.New(t, defaultMTU)
+	defer c.Cleanup()
+
+	opt := tcpip.TCPSynCookiesAlways
+	if err := c.Stack().SetTransportProtocolOption(tcp.ProtocolNumber, &opt); err != nil {
+		t.Fatalf("SetTransportProtocolOption(%d, &%T(%d)): %s", tcp.ProtocolNumber, opt, opt, err)
+	}
+
+	wq := &waiter.Queue{}
+	ep, err := c.Stack().NewEndpoint(tcp.ProtocolNumber, ipv4.ProtocolNumber, wq)
+	if err != nil {
+		t.Fatalf("NewEndpoint failed: %s", err)
+	}
+	defer ep.Close()
+	if err := ep.Bind(tcpip.FullAddress{Port: context.StackPort}); err != nil {
+		t.Fatalf("Bind failed: %s", err)
+	}
+	if err := ep.Listen(10); err != nil {
+		t.Fatalf("Listen failed: %s", err)
+	}
+
+	// The handshake completes without the listener keeping any state for
+	// it, which is why the SYN-ACK doesn't enable window scaling.
+	c.PassiveConnect(defaultIPv4MSS, -1, header.TCPSynOptions{MSS: defaultIPv4MSS})
+
+	we, ch := waiter.NewChannelEntry(nil)
+	wq.EventRegister(&we, waiter.EventIn)
+	defer wq.EventUnregister(&we)
+
+	c.EP, _, err = ep.Accept(nil)
+	if err == tcpip.ErrWouldBlock {
+		select {
+		case <-ch:
+			c.EP, _, err = ep.Accept(nil)
+			if err != nil {
+				t.Fatalf("Accept failed: %s", err)
+			}
+
+		case <-time.After(1 * time.Second):
+			t.Fatalf("Timed out waiting for accept")
+		}
+	}
+
+	if got := c.Stack().Stats().TCP.ListenOverflowSynCookieSent.Value(); got != 1 {
+		t.Errorf("got stats.TCP.ListenOverflowSynCookieSent.Value() = %d, want = 1", got)
+	}
+	if got := c.Stack().Stats().TCP.ListenOverflowSynCookieRcvd.Value(); got != 1 {
+		t.Errorf("got stats.TCP.ListenOverflowSynCookieRcvd.Value() = %d, want = 1", got)
+	}
+}
+
+func TestListenBacklogFullAbortOnOverflow(t *testing.T) {
+	c := context.New(t, defaultMTU)
+	defer c.Cleanup()
+
+	// Use SYN cookies for all connections, so that the final ACKs reach the
+	// listener.
+	cookies := tcpip.TCPSynCookiesAlways
+	if err := c.Stack().SetTransportProtocolOption(tcp.ProtocolNumber, &cookies); err != nil {
+		t.Fatalf("SetTransportProtocolOption(%d, &%T(%d)): %s", tcp.ProtocolNumber, cookies, cookies, err)
+	}
+	abort := tcpip.TCPAbortOnOverflowOption(true)
+	if err := c.Stack().SetTransportProtocolOption(tcp.ProtocolNumber, &abort); err != nil {
+		t.Fatalf("SetTransportProtocolOption(%d, &%T(%t)): %s", tcp.ProtocolNumber, abort, abort, err)
+	}
+
+	var err *tcpip.Error
+	c.EP, err = c.Stack().NewEndpoint(tcp.ProtocolNumber, ipv4.ProtocolNumber, &c.WQ)
+	if err != nil {
+		t.Fatalf("NewEndpoint failed: %s", err)
+	}
+	if err := c.EP.Bind(tcpip.FullAddress{Port: context.StackPort}); err != nil {
+		t.Fatalf("Bind failed: %s", err)
+	}
+	if err := c.EP.Listen(1); err != nil {
+		t.Fatalf("Listen failed: %s", err)
+	}
+
+	// Send two SYNs and get their SYN cookies.
+	const irs = seqnum.Value(789)
+	var synCookies [2]seqnum.Value
+	for i := range synCookies {
+		c.SendPacket(nil, &context.Headers{
+			SrcPort: context.TestPort + uint16(i),
+			DstPort: context.StackPort,
+			Flags:   header.TCPFlagSyn,
+			SeqNum:  irs,
+			RcvWnd:  30000,
+		})
+		b := c.GetPacket()
+		checker.IPv4(t, b, checker.TCP(
+			checker.DstPort(context.TestPort+uint16(i)),
+			checker.TCPFlags(header.TCPFlagSyn|header.TCPFlagAck)))
+		synCookies[i] = seqnum.Value(header.TCP(header.IPv4(b).Payload()).SequenceNumber())
+	}
+
+	// The first connection fills the accept queue.
+	c.SendPacket(nil, &context.Headers{
+		SrcPort: context.TestPort,
+		DstPort: context.StackPort,
+		Flags:   header.TCPFlagAck,
+		SeqNum:  irs + 1,
+		AckNum:  synCookies[0] + 1,
+		RcvWnd:  30000,
+	})
+	c.CheckNoPacketTimeout("unexpected packet received", 50*time.Millisecond)
+
+	// The second one is aborted.
+	c.SendPacket(nil, &context.Headers{
+		SrcPort: context.TestPort + 1,
+		DstPort: context.StackPort,
+		Flags:   header.TCPFlagAck,
+		SeqNum:  irs + 1,
+		AckNum:  synCookies[1] + 1,
+		RcvWnd:  30000,
+	})
+	checker.IPv4(t, c.GetPacket(), checker.TCP(
+		checker.SrcPort(context.StackPort),
+		checker.DstPort(context.TestPort+1),
+		checker.TCPSeqNum(uint32(synCookies[1]+1)),
+		checker.TCPFlags(header.TCPFlagRst)))
+
+	if got := c.Stack().Stats().TCP.ListenOverflowAckReset.Value(); got != 1 {
+		t.Errorf("got stats.TCP.ListenOverflowAckReset.Value() = %d, want = 1", got)
+	}
+}
+
+func TestListenCloseMigrateReq(t *testing.T) {
+	for _, migrate := range []bool{false, true} {
+		t.Run(fmt.Sprintf("migrate=%t", migrate), func(t *testing.T) {
+			c := context.New(t, defaultMTU)
+			defer c.Cleanup()
+
+			opt := tcpip.TCPMigrateReqOption(migrate)
+			if err := c.Stack().SetTransportProtocolOption(tcp.ProtocolNumber, &opt); err != nil {
+				t.Fatalf("SetTransportProtocolOption(%d, &%T(%t)): %s", tcp.ProtocolNumber, opt, opt, err)
+			}
+
+			listen := func(wq *waiter.Queue) tcpip.Endpoint {
+				t.Helper()
+				ep, err := c.Stack().NewEndpoint(tcp.ProtocolNumber, ipv4.ProtocolNumber, wq)
+				if err != nil {
+					t.Fatalf("NewEndpoint failed: %s", err)
+				}
+				ep.SocketOptions().SetReusePort(true)
+				if err := ep.Bind(tcpip.FullAddress{Port: context.StackPort}); err != nil {
+					t.Fatalf("Bind failed: %s", err)
+				}
+				if err := ep.Listen(10); err != nil {
+					t.Fatalf("Listen failed: %s", err)
+				}
+				return ep
+			}
+
+			// Queue a connection on the first listener.
+			var oldWQ waiter.Queue
+			oldEP := listen(&oldWQ)
+			we, ch := waiter.NewChannelEntry(nil)
+			oldWQ.EventRegister(&we, waiter.EventIn)
+			c.PassiveConnect(100, -1, header.TCPSynOptions{MSS: 100, WS: -1})
+			select {
+			case <-ch:
+			case <-time.After(1 * time.Second):
+				t.Fatalf("timed out waiting for the connection to be queued")
+			}
+			oldWQ.EventUnregister(&we)
+
+			// Start a second listener in the same SO_REUSEPORT group and
+			// close the first one, as when restarting a server.
+			var newWQ waiter.Queue
+			newEP := listen(&newWQ)
+			defer newEP.Close()
+			we, ch = waiter.NewChannelEntry(nil)
+			newWQ.EventRegister(&we, waiter.EventIn)
+			defer newWQ.EventUnregister(&we)
+			oldEP.Close()
+
+			if !migrate {
+				checker.IPv4(t, c.GetPacket(), checker.TCP(
+					checker.SrcPort(context.StackPort),
+					checker.DstPort(context.TestPort),
+					checker.TCPFlags(header.TCPFlagAck|header.TCPFlagRst)))
+				if _, _, err := newEP.Accept(nil); err != tcpip.ErrWouldBlock {
+					t.Fatalf("got newEP.Accept(nil) = %s, want = %s", err, tcpip.ErrWouldBlock)
+				}
+				return
+			}
+
+			// The connection is accepted by the second listener.
+			select {
+			case <-ch:
+			case <-time.After(1 * time.Second):
+				t.Fatalf("timed out waiting for the connection to be migrated")
+			}
+			var addr tcpip.FullAddress
+			ep, _, err := newEP.Accept(&addr)
+			if err != nil {
+				t.Fatalf("newEP.Accept(_) = %s", err)
+			}
+			defer ep.Close()
+			if addr.Addr != context.TestAddr || addr.Port != context.TestPort {
+				t.Errorf("got accepted address = %+v, want = %s:%d", addr, context.TestAddr, context.TestPort)
+			}
+			c.CheckNoPacket("unexpected packet after closing the first listener")
+			if got := c.Stack().Stats().TCP.MigrateReqSuccess.Value(); got != 1 {
+				t.Errorf("got stats.TCP.MigrateReqSuccess.Value() = %d, want = 1", got)
+			}
+		})
+	}
+}
+
 func TestSYNRetransmit(t *testing.T) {
 	c := context.New(t, defaultMTU)
 	defer c.Cleanup()
@@ -7291,6 +7720,55 @@ func TestTCPUserTimeout(t *testing.T) {
 	}
 }
 
+func TestTCPUserTimeoutSynSent(t *testing.T) {
+	c := context.New(t, defaultMTU)
+	defer c.Cleanup()
+
+	c.Create(-1)
+
+	waitEntry, notifyCh := waiter.NewChannelEntry(nil)
+	c.WQ.EventRegister(&waitEntry, waiter.EventHUp)
+	defer c.WQ.EventUnregister(&waitEntry)
+
+	// Ensure that the user timeout expires before the first SYN
+	// retransmission.
+	initRTO := 1 * time.Second
+	userTimeout := initRTO / 2
+	v := tcpip.TCPUserTimeoutOption(userTimeout)
+	if err := c.EP.SetSockOpt(&v); err != nil {
+		t.Fatalf("c.EP.SetSockOpt(&%T(%s): %s", v, userTimeout, err)
+	}
+
+	addr := tcpip.FullAddress{Addr: context.TestAddr, Port: context.TestPort}
+	if err := c.EP.Connect(addr); err != tcpip.ErrConnectStarted {
+		t.Fatalf("got Connect(%+v) = %s, want %s", addr, err, tcpip.ErrConnectStarted)
+	}
+
+	checker.IPv4(t, c.GetPacket(),
+		checker.TCP(
+			checker.DstPort(context.TestPort),
+			checker.TCPFlags(header.TCPFlagSyn),
+		),
+	)
+
+	// The handshake should be aborted once the user timeout expires.
+	select {
+	case <-notifyCh:
+	case <-time.After(initRTO):
+		t.Fatalf("connection still alive after %s, should have been closed after %s", initRTO, userTimeout)
+	}
+
+	// The SYN should not have been retransmitted.
+	c.CheckNoPacket("unexpected packet received after userTimeout has expired")
+
+	ept := endpointTester{c.EP}
+	ept.CheckReadError(t, tcpip.ErrTimeout)
+
+	if got, want := tcp.EndpointState(c.EP.State()), tcp.StateError; got != want {
+		t.Errorf("got State() = %s, want %s", got, want)
+	}
+}
+
 func TestKeepaliveWithUserTimeout(t *testing.T) {
 	c := context.New(t, defaultMTU)
 	defer c.Cleanup()
@@ -7740,3 +8218,331 @@ func generateRandomPayload(t *testing.T, n int) []byte {
 	}
 	return buf
 }
+
+// fastOpenOptions returns TCP options carrying a TCP Fast Open option with the
+// given cookie, padded to a multiple of 4 bytes.
+func fastOpenOptions(cookie []byte) []byte {
+	opts := make([]byte, header.TCPOptionsMaximumSize)
+	offset := header.EncodeFastOpenOption(cookie, opts)
+	offset += header.AddTCPOptionPadding(opts, offset)
+	return opts[:offset]
+}
+
+func TestTCPFastOpenServer(t *testing.T) {
+	c := context.New(t, defaultMTU)
+	defer c.Cleanup()
+
+	c.Create(-1)
+	if err := c.EP.Bind(tcpip.FullAddress{Port: context.StackPort}); err != nil {
+		t.Fatal("Bind failed:", err)
+	}
+	if err := c.EP.Listen(10); err != nil {
+		t.Fatal("Listen failed:", err)
+	}
+	const fastOpenQueueLen = 5
+	if err := c.EP.SetSockOptInt(tcpip.TCPFastOpenOption, fastOpenQueueLen); err != nil {
+		t.Fatalf("c.EP.SetSockOptInt(tcpip.TCPFastOpenOption, %d): %s", fastOpenQueueLen, err)
+	}
+
+	// Request a cookie.
+	irs := seqnum.Value(789)
+	c.SendPacket(nil, &context.Headers{
+		SrcPort: context.TestPort,
+		DstPort: context.StackPort,
+		Flags:   header.TCPFlagSyn,
+		SeqNum:  irs,
+		RcvWnd:  30000,
+		TCPOpts: fastOpenOptions(nil),
+	})
+	b := c.GetPacket()
+	checker.IPv4(t, b, checker.TCP(
+		checker.DstPort(context.TestPort),
+		checker.TCPFlags(header.TCPFlagSyn|header.TCPFlagAck),
+		checker.TCPAckNum(uint32(irs)+1)))
+	synAckOpts := header.ParseSynOptions(header.TCP(header.IPv4(b).Payload()).Options(), true /* isAck */)
+	if !synAckOpts.FastOpen || len(synAckOpts.FastOpenCookie) == 0 {
+		t.Fatalf("got SYN-ACK options = %+v, want a TCP Fast Open cookie", synAckOpts)
+	}
+	cookie := synAckOpts.FastOpenCookie
+
+	// Use the cookie to send data along with the SYN of a new connection.
+	const srcPort = context.TestPort + 1
+	data := []byte{1, 2, 3, 4}
+	c.SendPacket(data, &context.Headers{
+		SrcPort: srcPort,
+		DstPort: context.StackPort,
+		Flags:   header.TCPFlagSyn,
+		SeqNum:  irs,
+		RcvWnd:  30000,
+		TCPOpts: fastOpenOptions(cookie),
+	})
+
+	// The SYN-ACK must acknowledge the SYN data.
+	b = c.GetPacket()
+	tcpHdr := header.TCP(header.IPv4(b).Payload())
+	iss := seqnum.Value(tcpHdr.SequenceNumber())
+	checker.IPv4(t, b, checker.TCP(
+		checker.DstPort(srcPort),
+		checker.TCPFlags(header.TCPFlagSyn|header.TCPFlagAck),
+		checker.TCPAckNum(uint32(irs)+1+uint32(len(data)))))
+
+	c.SendPacket(nil, &context.Headers{
+		SrcPort: srcPort,
+		DstPort: context.StackPort,
+		Flags:   header.TCPFlagAck,
+		SeqNum:  irs.Add(1 + seqnum.Size(len(data))),
+		AckNum:  iss + 1,
+		RcvWnd:  30000,
+	})
+
+	// Give a bit of time for the socket to be delivered to the accept queue.
+	time.Sleep(50 * time.Millisecond)
+	aep, _, err := c.EP.Accept(nil)
+	if err != nil {
+		t.Fatalf("got c.EP.Accept(nil) = %s, want: nil", err)
+	}
+	defer aep.Close()
+
+	var buf bytes.Buffer
+	if _, err := aep.Read(&buf, tcpip.ReadOptions{}); err != nil {
+		t.Fatalf("aep.Read(_, {}): %s", err)
+	}
+	if got := buf.Bytes(); !bytes.Equal(got, data) {
+		t.Errorf("got aep.Read(_, {}) = %v, want = %v", got, data)
+	}
+}
+
+func TestTCPFastOpenClient(t *testing.T) {
+	c := context.New(t, defaultMTU)
+	defer c.Cleanup()
+
+	// The first connection has no cookie, so the SYN carries a cookie
+	// request.
+	c.Create(-1)
+	if err := c.EP.SetSockOptInt(tcpip.TCPFastOpenConnectOption, 1); err != nil {
+		t.Fatalf("c.EP.SetSockOptInt(tcpip.TCPFastOpenConnectOption, 1): %s", err)
+	}
+	if err := c.EP.Connect(tcpip.FullAddress{Addr: context.TestAddr, Port: context.TestPort}); err != tcpip.ErrConnectStarted {
+		t.Fatalf("got c.EP.Connect(...) = %s, want = %s", err, tcpip.ErrConnectStarted)
+	}
+	b := c.GetPacket()
+	tcpHdr := header.TCP(header.IPv4(b).Payload())
+	synOpts := header.ParseSynOptions(tcpHdr.Options(), false /* isAck */)
+	if !synOpts.FastOpen || len(synOpts.FastOpenCookie) != 0 {
+		t.Fatalf("got SYN options = %+v, want a TCP Fast Open cookie request", synOpts)
+	}
+
+	cookie := []byte{1, 2, 3, 4, 5, 6, 7, 8}
+	iss := seqnum.Value(789)
+	c.SendPacket(nil, &context.Headers{
+		SrcPort: tcpHdr.DestinationPort(),
+		DstPort: tcpHdr.SourcePort(),
+		Flags:   header.TCPFlagSyn | header.TCPFlagAck,
+		SeqNum:  iss,
+		AckNum:  seqnum.Value(tcpHdr.SequenceNumber()) + 1,
+		RcvWnd:  30000,
+		TCPOpts: fastOpenOptions(cookie),
+	})
+	checker.IPv4(t, c.GetPacket(), checker.TCP(
+		checker.DstPort(context.TestPort),
+		checker.TCPFlags(header.TCPFlagAck)))
+	c.EP.Close()
+	// Consume the FIN and reset the connection so that the FIN is not
+	// retransmitted.
+	fin := header.TCP(header.IPv4(c.GetPacket()).Payload())
+	c.SendPacket(nil, &context.Headers{
+		SrcPort: context.TestPort,
+		DstPort: fin.SourcePort(),
+		Flags:   header.TCPFlagRst,
+		SeqNum:  iss + 1,
+	})
+
+	// With the cookie cached, connect(2) completes right away and the SYN
+	// is only sent along with the first write.
+	c.Create(-1)
+	if err := c.EP.SetSockOptInt(tcpip.TCPFastOpenConnectOption, 1); err != nil {
+		t.Fatalf("c.EP.SetSockOptInt(tcpip.TCPFastOpenConnectOption, 1): %s", err)
+	}
+	if err := c.EP.Connect(tcpip.FullAddress{Addr: context.TestAddr, Port: context.TestPort}); err != nil {
+		t.Fatalf("got c.EP.Connect(...) = %s, want = nil", err)
+	}
+	c.CheckNoPacket("unexpected packet before the first write")
+
+	data := []byte{1, 2, 3}
+	var r bytes.Reader
+	r.Reset(data)
+	if n, err := c.EP.Write(&r, tcpip.WriteOptions{}); err != nil || n != int64(len(data)) {
+		t.Fatalf("got c.EP.Write(...) = (%d, %s), want = (%d, nil)", n, err, len(data))
+	}
+
+	b = c.GetPacket()
+	tcpHdr = header.TCP(header.IPv4(b).Payload())
+	checker.IPv4(t, b, checker.TCP(
+		checker.DstPort(context.TestPort),
+		checker.TCPFlags(header.TCPFlagSyn),
+		checker.Payload(data)))
+	synOpts = header.ParseSynOptions(tcpHdr.Options(), false /* isAck */)
+	if !synOpts.FastOpen || !bytes.Equal(synOpts.FastOpenCookie, cookie) {
+		t.Fatalf("got SYN options = %+v, want TCP Fast Open cookie %v", synOpts, cookie)
+	}
+
+	// Acknowledge the SYN and its data; nothing must be retransmitted.
+	irs := seqnum.Value(tcpHdr.SequenceNumber())
+	c.SendPacket(nil, &context.Headers{
+		SrcPort: context.TestPort,
+		DstPort: tcpHdr.SourcePort(),
+		Flags:   header.TCPFlagSyn | header.TCPFlagAck,
+		SeqNum:  iss,
+		AckNum:  irs.Add(1 + seqnum.Size(len(data))),
+		RcvWnd:  30000,
+	})
+	checker.IPv4(t, c.GetPacket(), checker.TCP(
+		checker.DstPort(context.TestPort),
+		checker.TCPFlags(header.TCPFlagAck),
+		checker.TCPSeqNum(uint32(irs)+1+uint32(len(data))),
+		checker.TCPAckNum(uint32(iss)+1)))
+	c.CheckNoPacketTimeout("unexpected retransmission of the SYN data", 500*time.Millisecond)
+}
+
+// md5SignedSegment builds a segment like c.BuildSegment, with a TCP MD5
+// signature computed using key prepended to its options.
+func md5SignedSegment(c *context.Context, payload []byte, h *context.Headers, key []byte) buffer.VectorisedView {
+	opts := make([]byte, 2+header.TCPOptionMD5Length, 2+header.TCPOptionMD5Length+len(h.TCPOpts))
+	header.EncodeNOP(opts)
+	header.EncodeNOP(opts[1:])
+	header.EncodeMD5Option(opts[2:])
+	signed := *h
+	signed.TCPOpts = append(opts, h.TCPOpts...)
+
+	vv := c.BuildSegment(payload, &signed)
+	b := vv.ToView()
+	tcpHdr := header.TCP(header.IPv4(b).Payload())
+	tcpHdr = tcpHdr[:tcpHdr.DataOffset()]
+	digest := header.TCPMD5Digest(context.TestAddr, context.StackAddr, tcpHdr, buffer.View(payload).ToVectorisedView(), key)
+	copy(tcpHdr[header.TCPMinimumSize+4:], digest[:])
+
+	// The signature is covered by the checksum.
+	tcpHdr.SetChecksum(0)
+	xsum := header.PseudoHeaderChecksum(tcp.ProtocolNumber, context.TestAddr, context.StackAddr, uint16(len(tcpHdr)+len(payload)))
+	xsum = header.Checksum(payload, xsum)
+	tcpHdr.SetChecksum(^tcpHdr.CalculateChecksum(xsum))
+	return b.ToVectorisedView()
+}
+
+// checkMD5Signature checks that the TCP segment in the IPv4 packet b sent by
+// the stack is signed with key.
+func checkMD5Signature(t *testing.T, b []byte, key []byte) {
+	t.Helper()
+	tcpHdr := header.TCP(header.IPv4(b).Payload())
+	payload := tcpHdr.Payload()
+	tcpHdr = tcpHdr[:tcpHdr.DataOffset()]
+	got := header.ParseMD5Option(tcpHdr.Options())
+	if got == nil {
+		t.Fatalf("got segment without a TCP MD5 signature option, options = %v", tcpHdr.Options())
+	}
+	want := header.TCPMD5Digest(context.StackAddr, context.TestAddr, tcpHdr, buffer.View(payload).ToVectorisedView(), key)
+	if !bytes.Equal(got, want[:]) {
+		t.Fatalf("got TCP MD5 signature = %x, want = %x", got, want)
+	}
+}
+
+func TestTCPMD5SigOption(t *testing.T) {
+	c := context.New(t, defaultMTU)
+	defer c.Cleanup()
+
+	c.Create(-1)
+	for _, tc := range []struct {
+		name string
+		opt  tcpip.TCPMD5SigOption
+		want *tcpip.Error
+	}{
+		{"Remove unknown key", tcpip.TCPMD5SigOption{Addr: context.TestAddr, PrefixLen: 32}, tcpip.ErrNoSuchFile},
+		{"Prefix too long", tcpip.TCPMD5SigOption{Addr: context.TestAddr, PrefixLen: 33, Key: []byte("key")}, tcpip.ErrInvalidOptionValue},
+		{"Key too long", tcpip.TCPMD5SigOption{Addr: context.TestAddr, PrefixLen: 32, Key: make([]byte, tcpip.TCPMD5SigMaxKeyLen+1)}, tcpip.ErrInvalidOptionValue},
+		{"Add key", tcpip.TCPMD5SigOption{Addr: context.TestAddr, PrefixLen: 32, Key: []byte("key")}, nil},
+		{"Replace key", tcpip.TCPMD5SigOption{Addr: context.TestAddr, PrefixLen: 32, Key: []byte("other")}, nil},
+		{"Remove key", tcpip.TCPMD5SigOption{Addr: context.TestAddr, PrefixLen: 32}, nil},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			opt := tc.opt
+			if err := c.EP.SetSockOpt(&opt); err != tc.want {
+				t.Errorf("got c.EP.SetSockOpt(%+v) = %v, want = %v", tc.opt, err, tc.want)
+			}
+		})
+	}
+}
+
+func TestTCPMD5SignedConnection(t *testing.T) {
+	c := context.New(t, defaultMTU)
+	defer c.Cleanup()
+
+	key := []byte("bgp-secret")
+	c.Create(-1)
+	// Install the key for the whole 10.0.0.0/8 prefix of the peer.
+	opt := tcpip.TCPMD5SigOption{Addr: "\x0a\x00\x00\x00", PrefixLen: 8, Key: key}
+	if err := c.EP.SetSockOpt(&opt); err != nil {
+		t.Fatalf("c.EP.SetSockOpt(%+v): %s", opt, err)
+	}
+	if err := c.EP.Connect(tcpip.FullAddress{Addr: context.TestAddr, Port: context.TestPort}); err != tcpip.ErrConnectStarted {
+		t.Fatalf("got c.EP.Connect(...) = %s, want = %s", err, tcpip.ErrConnectStarted)
+	}
+	b := c.GetPacket()
+	checker.IPv4(t, b, checker.TCP(
+		checker.DstPort(context.TestPort),
+		checker.TCPFlags(header.TCPFlagSyn)))
+	checkMD5Signature(t, b, key)
+	tcpHdr := header.TCP(header.IPv4(b).Payload())
+	irs := seqnum.Value(tcpHdr.SequenceNumber())
+	port := tcpHdr.SourcePort()
+
+	// An unsigned SYN-ACK is dropped.
+	iss := seqnum.Value(789)
+	synAck := &context.Headers{
+		SrcPort: context.TestPort,
+		DstPort: port,
+		Flags:   header.TCPFlagSyn | header.TCPFlagAck,
+		SeqNum:  iss,
+		AckNum:  irs + 1,
+		RcvWnd:  30000,
+	}
+	c.SendPacket(nil, synAck)
+	c.CheckNoPacketTimeout("unexpected packet in response to an unsigned SYN-ACK", 100*time.Millisecond)
+	if got := c.Stack().Stats().TCP.MD5SignatureErrors.Value(); got != 1 {
+		t.Errorf("got stats.TCP.MD5SignatureErrors.Value() = %d, want = 1", got)
+	}
+
+	// A signed SYN-ACK completes the handshake, and the ACK is signed too.
+	c.SendSegment(md5SignedSegment(c, nil, synAck, key))
+	b = c.GetPacket()
+	checker.IPv4(t, b, checker.TCP(
+		checker.DstPort(context.TestPort),
+		checker.TCPFlags(header.TCPFlagAck),
+		checker.TCPSeqNum(uint32(irs)+1),
+		checker.TCPAckNum(uint32(iss)+1)))
+	checkMD5Signature(t, b, key)
+
+	// Data segments are signed in both directions.
+	data := []byte{1, 2, 3}
+	c.SendSegment(md5SignedSegment(c, data, &context.Headers{
+		SrcPort: context.TestPort,
+		DstPort: port,
+		Flags:   header.TCPFlagAck,
+		SeqNum:  iss + 1,
+		AckNum:  irs + 1,
+		RcvWnd:  30000,
+	}, key))
+	b = c.GetPacket()
+	checker.IPv4(t, b, checker.TCP(
+		checker.DstPort(context.TestPort),
+		checker.TCPFlags(header.TCPFlagAck),
+		checker.TCPAckNum(uint32(iss)+1+uint32(len(data)))))
+	checkMD5Signature(t, b, key)
+
+	var buf bytes.Buffer
+	if _, err := c.EP.Read(&buf, tcpip.ReadOptions{}); err != nil {
+		t.Fatalf("c.EP.Read(...): %s", err)
+	}
+	if got := buf.Bytes(); !bytes.Equal(got, data) {
+		t.Errorf("got c.EP.Read(...) = %v, want = %v", got, data)
+	}
+}
@@ -1221,6 +1221,16 @@ func (c *Context) SetGSOEnabled(enable bool) {
 	}
 }
 
+// SetSaveRestoreEnabled sets or clears the link endpoint's save/restore
+// capability, which is required to save connected endpoints.
+func (c *Context) SetSaveRestoreEnabled(enable bool) {
+	if enable {
+		c.linkEP.LinkEPCapabilities |= stack.CapabilitySaveRestore
+	} else {
+		c.linkEP.LinkEPCapabilities &^= stack.CapabilitySaveRestore
+	}
+}
+
 // MSSWithoutOptions returns the value for the MSS used by the stack when no
 // options are in use.
 func (c *Context) MSSWithoutOptions() uint16 {
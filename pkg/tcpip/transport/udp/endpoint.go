@@ -38,8 +38,24 @@ type udpPacket struct {
 	timestamp          int64
 	// tos stores either the receiveTOS or receiveTClass value.
 	tos uint8
+	// ttl stores either the TTL or the hop limit of the packet.
+	ttl uint8
+	// flowInfo stores the traffic class and flow label of IPv6 packets.
+	flowInfo uint32
+	// netProto is the network protocol the packet was received over.
+	netProto tcpip.NetworkProtocolNumber
 }
 
+// maxGSOSegments is the maximum number of datagrams that a single write may
+// be split into, or that may be coalesced into a single read, when UDP
+// segmentation offload is used. This matches Linux's UDP_MAX_SEGMENTS.
+const maxGSOSegments = 64
+
+// maxMulticastSources is the maximum number of sources in the source filter of
+// a multicast group membership. This matches Linux's default
+// net.ipv4.igmp_max_msf.
+const maxMulticastSources = 10
+
 // EndpointState represents the state of a UDP endpoint.
 type EndpointState uint32
 
@@ -95,6 +111,9 @@ type endpoint struct {
 	rcvBufSizeMax int `state:".(int)"`
 	rcvBufSize    int
 	rcvClosed     bool
+	// gro is true if consecutive datagrams may be coalesced when read, as
+	// set using the UDP_GRO socket option.
+	gro bool
 
 	// The following fields are protected by the mu mutex.
 	mu            sync.RWMutex `state:"nosave"`
@@ -123,12 +142,17 @@ type endpoint struct {
 	// applied while sending packets. Defaults to 0 as on Linux.
 	sendTOS uint8
 
+	// gsoSize is the size of the datagrams that the payload of writes is
+	// split into, as set using the UDP_SEGMENT socket option. Zero
+	// disables segmentation.
+	gsoSize int
+
 	// shutdownFlags represent the current shutdown state of the endpoint.
 	shutdownFlags tcpip.ShutdownFlags
 
 	// multicastMemberships that need to be remvoed when the endpoint is
-	// closed. Protected by the mu mutex.
-	multicastMemberships map[multicastMembership]struct{}
+	// closed, along with their source filters. Protected by the mu mutex.
+	multicastMemberships map[multicastMembership]tcpip.MulticastSourceFilter
 
 	// effectiveNetProtos contains the network protocols actually in use. In
 	// most cases it will only contain "netProto", but in cases like IPv6
@@ -177,7 +201,7 @@ func newEndpoint(s *stack.Stack, netProto tcpip.NetworkProtocolNumber, waiterQue
 		multicastTTL:         1,
 		rcvBufSizeMax:        32 * 1024,
 		sndBufSizeMax:        32 * 1024,
-		multicastMemberships: make(map[multicastMembership]struct{}),
+		multicastMemberships: make(map[multicastMembership]tcpip.MulticastSourceFilter),
 		state:                StateInitial,
 		uniqueID:             s.UniqueID(),
 	}
@@ -252,10 +276,11 @@ func (e *endpoint) Close() {
 		e.boundPortFlags = ports.Flags{}
 	}
 
-	for mem := range e.multicastMemberships {
-		e.stack.LeaveGroup(e.NetProto, mem.nicID, mem.multicastAddr)
+	for mem, filter := range e.multicastMemberships {
+		filter := filter
+		e.stack.ChangeGroupSourceFilter(e.NetProto, mem.nicID, mem.multicastAddr, &filter, nil)
 	}
-	e.multicastMemberships = make(map[multicastMembership]struct{})
+	e.multicastMemberships = make(map[multicastMembership]tcpip.MulticastSourceFilter)
 
 	// Close the receive list and drain it.
 	e.rcvMu.Lock()
@@ -302,9 +327,13 @@ func (e *endpoint) Read(dst io.Writer, opts tcpip.ReadOptions) (tcpip.ReadResult
 	}
 
 	p := e.rcvList.Front()
+	var groSize int
 	if !opts.Peek {
 		e.rcvList.Remove(p)
 		e.rcvBufSize -= p.data.Size()
+		if e.gro {
+			groSize = e.coalesceLocked(p)
+		}
 	}
 	e.rcvMu.Unlock()
 
@@ -313,6 +342,10 @@ func (e *endpoint) Read(dst io.Writer, opts tcpip.ReadOptions) (tcpip.ReadResult
 		HasTimestamp: true,
 		Timestamp:    p.timestamp,
 	}
+	if groSize != 0 {
+		cm.HasGROSize = true
+		cm.GROSize = uint16(groSize)
+	}
 	if e.ops.GetReceiveTOS() {
 		cm.HasTOS = true
 		cm.TOS = p.tos
@@ -326,6 +359,29 @@ func (e *endpoint) Read(dst io.Writer, opts tcpip.ReadOptions) (tcpip.ReadResult
 		cm.HasIPPacketInfo = true
 		cm.PacketInfo = p.packetInfo
 	}
+	switch p.netProto {
+	case header.IPv4ProtocolNumber:
+		if e.ops.GetReceiveTTL() {
+			cm.HasTTL = true
+			cm.TTL = p.ttl
+		}
+	case header.IPv6ProtocolNumber:
+		if e.ops.GetReceiveHopLimit() {
+			cm.HasHopLimit = true
+			cm.HopLimit = p.ttl
+		}
+		if e.ops.GetReceiveFlowInfo() {
+			cm.HasFlowInfo = true
+			cm.FlowInfo = p.flowInfo
+		}
+		if e.ops.GetReceiveIPv6PacketInfo() {
+			cm.HasIPv6PacketInfo = true
+			cm.IPv6PacketInfo = tcpip.IPv6PacketInfo{
+				Addr: p.packetInfo.DestinationAddr,
+				NIC:  p.packetInfo.NIC,
+			}
+		}
+	}
 	if e.ops.GetReceiveOriginalDstAddress() {
 		cm.HasOriginalDstAddress = true
 		cm.OriginalDstAddress = p.destinationAddress
@@ -348,6 +404,42 @@ func (e *endpoint) Read(dst io.Writer, opts tcpip.ReadOptions) (tcpip.ReadResult
 	return res, nil
 }
 
+// coalesceLocked appends to the datagram p, which was just removed from the
+// receive queue, the datagrams from the same sender that follow it in the
+// queue, as long as they are the same size as p. A shorter datagram ends the
+// batch. It returns the size of the coalesced datagrams, or zero if nothing
+// was coalesced.
+//
+// Precondition: e.rcvMu must be held.
+func (e *endpoint) coalesceLocked(p *udpPacket) int {
+	size := p.data.Size()
+	if size == 0 {
+		return 0
+	}
+	segs := 1
+	for segs < maxGSOSegments {
+		q := e.rcvList.Front()
+		if q == nil || q.senderAddress != p.senderAddress || q.destinationAddress != p.destinationAddress || q.tos != p.tos {
+			break
+		}
+		qSize := q.data.Size()
+		if qSize == 0 || qSize > size || p.data.Size()+qSize > header.UDPMaximumPacketSize {
+			break
+		}
+		e.rcvList.Remove(q)
+		e.rcvBufSize -= qSize
+		p.data.Append(q.data)
+		segs++
+		if qSize < size {
+			break
+		}
+	}
+	if segs == 1 {
+		return 0
+	}
+	return size
+}
+
 // prepareForWrite prepares the endpoint for sending data. In particular, it
 // binds it if it's still in the initial state. To do so, it must first
 // reacquire the mutex in exclusive mode.
@@ -392,6 +484,15 @@ func (e *endpoint) prepareForWrite(to *tcpip.FullAddress) (retry bool, err *tcpi
 // configured multicast interface if no interface is specified and the
 // specified address is a multicast address.
 func (e *endpoint) connectRoute(nicID tcpip.NICID, addr tcpip.FullAddress, netProto tcpip.NetworkProtocolNumber) (*stack.Route, tcpip.NICID, *tcpip.Error) {
+	// A socket bound to a device only routes through that device, even for
+	// multicast destinations.
+	if bindToDevice := tcpip.NICID(e.ops.GetBindToDevice()); bindToDevice != 0 {
+		if nicID != 0 && nicID != bindToDevice {
+			return nil, 0, tcpip.ErrNoRoute
+		}
+		nicID = bindToDevice
+	}
+
 	localAddr := e.ID.LocalAddress
 	if e.isBroadcastOrMulticast(nicID, netProto, localAddr) {
 		// A packet can only originate from a unicast address (i.e., an interface).
@@ -408,7 +509,7 @@ func (e *endpoint) connectRoute(nicID tcpip.NICID, addr tcpip.FullAddress, netPr
 	}
 
 	// Find a route to the desired destination.
-	r, err := e.stack.FindRoute(nicID, localAddr, addr.Addr, netProto, e.ops.GetMulticastLoop())
+	r, err := e.stack.FindRouteWithOptions(nicID, localAddr, addr.Addr, netProto, e.ops.GetMulticastLoop(), stack.RouteLookupOptions{Mark: e.ops.GetMark(), Transparent: e.ops.GetTransparent()})
 	if err != nil {
 		return nil, 0, err
 	}
@@ -550,6 +651,21 @@ func (e *endpoint) write(p tcpip.Payloader, opts tcpip.WriteOptions) (int64, *tc
 	sendTOS := e.sendTOS
 	owner := e.owner
 	noChecksum := e.SocketOptions().GetNoChecksum()
+
+	gsoSize := int(opts.GSOSize)
+	if gsoSize == 0 {
+		gsoSize = e.gsoSize
+	}
+	if gsoSize != 0 && len(v) > gsoSize {
+		// Each datagram must fit in a single IP packet and carry a
+		// checksum, and the payload may only be split into a limited
+		// number of datagrams. This matches Linux.
+		if gsoSize+header.UDPMinimumSize > int(route.MTU()) || len(v) > gsoSize*maxGSOSegments || noChecksum {
+			return 0, tcpip.ErrInvalidOptionValue
+		}
+	} else {
+		gsoSize = 0
+	}
 	lockReleased = true
 	e.mu.RUnlock()
 
@@ -563,6 +679,12 @@ func (e *endpoint) write(p tcpip.Payloader, opts tcpip.WriteOptions) (int64, *tc
 	//
 	// See: https://golang.org/pkg/sync/#RWMutex for details on why recursive read
 	// locking is prohibited.
+	if gsoSize != 0 {
+		if err := sendUDPSegments(route, v, gsoSize, localPort, dstPort, ttl, useDefaultTTL, sendTOS, owner, noChecksum); err != nil {
+			return 0, err
+		}
+		return int64(len(v)), nil
+	}
 	if err := sendUDP(route, buffer.View(v).ToVectorisedView(), localPort, dstPort, ttl, useDefaultTTL, sendTOS, owner, noChecksum); err != nil {
 		return 0, err
 	}
@@ -651,6 +773,19 @@ func (e *endpoint) SetSockOptInt(opt tcpip.SockOptInt, v int) *tcpip.Error {
 		e.sndBufSizeMax = v
 		e.mu.Unlock()
 		return nil
+
+	case tcpip.UDPSegmentOption:
+		if v < 0 || v > header.UDPMaximumPacketSize {
+			return tcpip.ErrInvalidOptionValue
+		}
+		e.mu.Lock()
+		e.gsoSize = v
+		e.mu.Unlock()
+
+	case tcpip.UDPGROOption:
+		e.rcvMu.Lock()
+		e.gro = v != 0
+		e.rcvMu.Unlock()
 	}
 
 	return nil
@@ -704,18 +839,7 @@ func (e *endpoint) SetSockOpt(opt tcpip.SettableSocketOption) *tcpip.Error {
 			return tcpip.ErrInvalidOptionValue
 		}
 
-		nicID := v.NIC
-
-		if v.InterfaceAddr.Unspecified() {
-			if nicID == 0 {
-				if r, err := e.stack.FindRoute(0, "", v.MulticastAddr, e.NetProto, false /* multicastLoop */); err == nil {
-					nicID = r.NICID()
-					r.Release()
-				}
-			}
-		} else {
-			nicID = e.stack.CheckLocalAddress(nicID, e.NetProto, v.InterfaceAddr)
-		}
+		nicID := e.multicastMembershipNIC(v.NIC, v.InterfaceAddr, v.MulticastAddr)
 		if nicID == 0 {
 			return tcpip.ErrUnknownDevice
 		}
@@ -733,24 +857,14 @@ func (e *endpoint) SetSockOpt(opt tcpip.SettableSocketOption) *tcpip.Error {
 			return err
 		}
 
-		e.multicastMemberships[memToInsert] = struct{}{}
+		e.multicastMemberships[memToInsert] = tcpip.MulticastSourceFilter{Mode: tcpip.MulticastFilterExclude}
 
 	case *tcpip.RemoveMembershipOption:
 		if !header.IsV4MulticastAddress(v.MulticastAddr) && !header.IsV6MulticastAddress(v.MulticastAddr) {
 			return tcpip.ErrInvalidOptionValue
 		}
 
-		nicID := v.NIC
-		if v.InterfaceAddr.Unspecified() {
-			if nicID == 0 {
-				if r, err := e.stack.FindRoute(0, "", v.MulticastAddr, e.NetProto, false /* multicastLoop */); err == nil {
-					nicID = r.NICID()
-					r.Release()
-				}
-			}
-		} else {
-			nicID = e.stack.CheckLocalAddress(nicID, e.NetProto, v.InterfaceAddr)
-		}
+		nicID := e.multicastMembershipNIC(v.NIC, v.InterfaceAddr, v.MulticastAddr)
 		if nicID == 0 {
 			return tcpip.ErrUnknownDevice
 		}
@@ -760,22 +874,137 @@ func (e *endpoint) SetSockOpt(opt tcpip.SettableSocketOption) *tcpip.Error {
 		e.mu.Lock()
 		defer e.mu.Unlock()
 
-		if _, ok := e.multicastMemberships[memToRemove]; !ok {
+		filter, ok := e.multicastMemberships[memToRemove]
+		if !ok {
 			return tcpip.ErrBadLocalAddress
 		}
 
-		if err := e.stack.LeaveGroup(e.NetProto, nicID, v.MulticastAddr); err != nil {
+		if err := e.stack.ChangeGroupSourceFilter(e.NetProto, nicID, v.MulticastAddr, &filter, nil); err != nil {
 			return err
 		}
 
 		delete(e.multicastMemberships, memToRemove)
 
+	case *tcpip.AddSourceMembershipOption:
+		return e.changeMembershipSource((*tcpip.SourceMembershipOption)(v), tcpip.MulticastFilterInclude, true /* add */)
+
+	case *tcpip.DropSourceMembershipOption:
+		return e.changeMembershipSource((*tcpip.SourceMembershipOption)(v), tcpip.MulticastFilterInclude, false /* add */)
+
+	case *tcpip.BlockSourceOption:
+		return e.changeMembershipSource((*tcpip.SourceMembershipOption)(v), tcpip.MulticastFilterExclude, true /* add */)
+
+	case *tcpip.UnblockSourceOption:
+		return e.changeMembershipSource((*tcpip.SourceMembershipOption)(v), tcpip.MulticastFilterExclude, false /* add */)
+
 	case *tcpip.SocketDetachFilterOption:
 		return nil
 	}
 	return nil
 }
 
+// multicastMembershipNIC returns the NIC a membership of the multicast group
+// multicastAddr identified by nicID and interfaceAddr refers to, or 0 if there
+// is no such NIC.
+func (e *endpoint) multicastMembershipNIC(nicID tcpip.NICID, interfaceAddr, multicastAddr tcpip.Address) tcpip.NICID {
+	if !interfaceAddr.Unspecified() {
+		return e.stack.CheckLocalAddress(nicID, e.NetProto, interfaceAddr)
+	}
+	if nicID == 0 {
+		if r, err := e.stack.FindRoute(0, "", multicastAddr, e.NetProto, false /* multicastLoop */); err == nil {
+			nicID = r.NICID()
+			r.Release()
+		}
+	}
+	return nicID
+}
+
+// changeMembershipSource adds the source of v to, or removes it from, the
+// source filter of the membership identified by v, which must be in filter
+// mode mode.
+//
+// Adding a source to a missing membership joins the group in include mode and
+// a membership in include mode is left once its last source is removed.
+func (e *endpoint) changeMembershipSource(v *tcpip.SourceMembershipOption, mode tcpip.MulticastFilterMode, add bool) *tcpip.Error {
+	switch {
+	case header.IsV4MulticastAddress(v.MulticastAddr):
+		if len(v.SourceAddr) != header.IPv4AddressSize {
+			return tcpip.ErrInvalidOptionValue
+		}
+	case header.IsV6MulticastAddress(v.MulticastAddr):
+		if len(v.SourceAddr) != header.IPv6AddressSize {
+			return tcpip.ErrInvalidOptionValue
+		}
+	default:
+		return tcpip.ErrInvalidOptionValue
+	}
+
+	nicID := e.multicastMembershipNIC(v.NIC, v.InterfaceAddr, v.MulticastAddr)
+	if nicID == 0 {
+		return tcpip.ErrUnknownDevice
+	}
+
+	mem := multicastMembership{nicID: nicID, multicastAddr: v.MulticastAddr}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	old, ok := e.multicastMemberships[mem]
+	if !ok {
+		if !add || mode != tcpip.MulticastFilterInclude {
+			return tcpip.ErrBadLocalAddress
+		}
+		new := tcpip.MulticastSourceFilter{Mode: mode, Sources: []tcpip.Address{v.SourceAddr}}
+		if err := e.stack.ChangeGroupSourceFilter(e.NetProto, nicID, v.MulticastAddr, nil, &new); err != nil {
+			return err
+		}
+		e.multicastMemberships[mem] = new
+		return nil
+	}
+
+	if old.Mode != mode {
+		return tcpip.ErrInvalidOptionValue
+	}
+
+	i := 0
+	for ; i < len(old.Sources); i++ {
+		if old.Sources[i] == v.SourceAddr {
+			break
+		}
+	}
+	found := i < len(old.Sources)
+
+	var sources []tcpip.Address
+	switch {
+	case add && found:
+		return tcpip.ErrPortInUse
+	case add:
+		if len(old.Sources) >= maxMulticastSources {
+			return tcpip.ErrNoBufferSpace
+		}
+		sources = append(append(sources, old.Sources...), v.SourceAddr)
+	case !found:
+		return tcpip.ErrBadLocalAddress
+	default:
+		sources = append(append(sources, old.Sources[:i]...), old.Sources[i+1:]...)
+	}
+
+	if mode == tcpip.MulticastFilterInclude && len(sources) == 0 {
+		if err := e.stack.ChangeGroupSourceFilter(e.NetProto, nicID, v.MulticastAddr, &old, nil); err != nil {
+			return err
+		}
+		delete(e.multicastMemberships, mem)
+		return nil
+	}
+
+	new := tcpip.MulticastSourceFilter{Mode: mode, Sources: sources}
+	if err := e.stack.ChangeGroupSourceFilter(e.NetProto, nicID, v.MulticastAddr, &old, &new); err != nil {
+		return err
+	}
+	e.multicastMemberships[mem] = new
+	return nil
+}
+
 // GetSockOptInt implements tcpip.Endpoint.GetSockOptInt.
 func (e *endpoint) GetSockOptInt(opt tcpip.SockOptInt) (int, *tcpip.Error) {
 	switch opt {
@@ -823,6 +1052,21 @@ func (e *endpoint) GetSockOptInt(opt tcpip.SockOptInt) (int, *tcpip.Error) {
 		e.rcvMu.Unlock()
 		return v, nil
 
+	case tcpip.UDPSegmentOption:
+		e.mu.RLock()
+		v := e.gsoSize
+		e.mu.RUnlock()
+		return v, nil
+
+	case tcpip.UDPGROOption:
+		e.rcvMu.Lock()
+		v := e.gro
+		e.rcvMu.Unlock()
+		if v {
+			return 1, nil
+		}
+		return 0, nil
+
 	case tcpip.TTLOption:
 		e.mu.Lock()
 		v := int(e.ttl)
@@ -854,6 +1098,73 @@ func (e *endpoint) GetSockOpt(opt tcpip.GettableSocketOption) *tcpip.Error {
 // sendUDP sends a UDP segment via the provided network endpoint and under the
 // provided identity.
 func sendUDP(r *stack.Route, data buffer.VectorisedView, localPort, remotePort uint16, ttl uint8, useDefaultTTL bool, tos uint8, owner tcpip.PacketOwner, noChecksum bool) *tcpip.Error {
+	pkt := buildUDPPacket(r, data, localPort, remotePort, owner, noChecksum)
+
+	if useDefaultTTL {
+		ttl = r.DefaultTTL()
+	}
+	if err := r.WritePacket(nil /* gso */, stack.NetworkHeaderParams{
+		Protocol: ProtocolNumber,
+		TTL:      ttl,
+		TOS:      tos,
+	}, pkt); err != nil {
+		r.Stats().UDP.PacketSendErrors.Increment()
+		return err
+	}
+
+	// Track count of packets sent.
+	r.Stats().UDP.PacketsSent.Increment()
+	return nil
+}
+
+// sendUDPSegments splits data into datagrams of segSize bytes, the last one
+// possibly being shorter, and sends them as a batch. This is the equivalent of
+// Linux's UDP segmentation offload.
+func sendUDPSegments(r *stack.Route, data buffer.View, segSize int, localPort, remotePort uint16, ttl uint8, useDefaultTTL bool, tos uint8, owner tcpip.PacketOwner, noChecksum bool) *tcpip.Error {
+	if r.Loop&stack.PacketLoop != 0 {
+		// Looped back packets can't be written as a batch.
+		for len(data) > 0 {
+			n := segSize
+			if n > len(data) {
+				n = len(data)
+			}
+			if err := sendUDP(r, data[:n].ToVectorisedView(), localPort, remotePort, ttl, useDefaultTTL, tos, owner, noChecksum); err != nil {
+				return err
+			}
+			data = data[n:]
+		}
+		return nil
+	}
+
+	var pkts stack.PacketBufferList
+	for len(data) > 0 {
+		n := segSize
+		if n > len(data) {
+			n = len(data)
+		}
+		pkts.PushBack(buildUDPPacket(r, data[:n].ToVectorisedView(), localPort, remotePort, owner, noChecksum))
+		data = data[n:]
+	}
+
+	if useDefaultTTL {
+		ttl = r.DefaultTTL()
+	}
+	count := pkts.Len()
+	sent, err := r.WritePackets(nil /* gso */, pkts, stack.NetworkHeaderParams{
+		Protocol: ProtocolNumber,
+		TTL:      ttl,
+		TOS:      tos,
+	})
+	r.Stats().UDP.PacketsSent.IncrementBy(uint64(sent))
+	if err != nil {
+		r.Stats().UDP.PacketSendErrors.IncrementBy(uint64(count - sent))
+		return err
+	}
+	return nil
+}
+
+// buildUDPPacket builds a UDP packet carrying data to be sent on r.
+func buildUDPPacket(r *stack.Route, data buffer.VectorisedView, localPort, remotePort uint16, owner tcpip.PacketOwner, noChecksum bool) *stack.PacketBuffer {
 	pkt := stack.NewPacketBuffer(stack.PacketBufferOptions{
 		ReserveHeaderBytes: header.UDPMinimumSize + int(r.MaxHeaderLength()),
 		Data:               data,
@@ -883,22 +1194,7 @@ func sendUDP(r *stack.Route, data buffer.VectorisedView, localPort, remotePort u
 		}
 		udp.SetChecksum(^udp.CalculateChecksum(xsum))
 	}
-
-	if useDefaultTTL {
-		ttl = r.DefaultTTL()
-	}
-	if err := r.WritePacket(nil /* gso */, stack.NetworkHeaderParams{
-		Protocol: ProtocolNumber,
-		TTL:      ttl,
-		TOS:      tos,
-	}, pkt); err != nil {
-		r.Stats().UDP.PacketSendErrors.Increment()
-		return err
-	}
-
-	// Track count of packets sent.
-	r.Stats().UDP.PacketsSent.Increment()
-	return nil
+	return pkt
 }
 
 // checkV4MappedLocked determines the effective network protocol and converts
@@ -1140,7 +1436,12 @@ func (e *endpoint) bindLocked(addr tcpip.FullAddress) *tcpip.Error {
 		// A local unicast address was specified, verify that it's valid.
 		nicID = e.stack.CheckLocalAddress(addr.NIC, netProto, addr.Addr)
 		if nicID == 0 {
-			return tcpip.ErrBadLocalAddress
+			// Transparent endpoints may bind to addresses not assigned to
+			// the stack.
+			if !e.ops.GetTransparent() {
+				return tcpip.ErrBadLocalAddress
+			}
+			nicID = addr.NIC
 		}
 	}
 
@@ -1259,9 +1560,30 @@ func verifyChecksum(hdr header.UDP, pkt *stack.PacketBuffer) bool {
 	return true
 }
 
+// multicastSourceAllowed returns false if the endpoint joined the multicast
+// group dst on the NIC with ID nicID with a source filter that does not allow
+// packets from src.
+func (e *endpoint) multicastSourceAllowed(nicID tcpip.NICID, dst, src tcpip.Address) bool {
+	if !header.IsV4MulticastAddress(dst) && !header.IsV6MulticastAddress(dst) {
+		return true
+	}
+
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	filter, ok := e.multicastMemberships[multicastMembership{nicID: nicID, multicastAddr: dst}]
+	return !ok || filter.Allows(src)
+}
+
 // HandlePacket is called by the stack when new packets arrive to this transport
 // endpoint.
 func (e *endpoint) HandlePacket(id stack.TransportEndpointID, pkt *stack.PacketBuffer) {
+	// As in Linux, only transparent endpoints accept the packets diverted by
+	// the TPROXY target.
+	if pkt.TProxy.Port != 0 && !e.ops.GetTransparent() {
+		e.stack.Stats().DroppedPackets.Increment()
+		return
+	}
+
 	// Get the header then trim it from the view.
 	hdr := header.UDP(pkt.TransportHeader().View())
 	if int(hdr.Length()) > pkt.Data.Size()+header.UDPMinimumSize {
@@ -1281,6 +1603,10 @@ func (e *endpoint) HandlePacket(id stack.TransportEndpointID, pkt *stack.PacketB
 	e.stack.Stats().UDP.PacketsReceived.Increment()
 	e.stats.PacketsReceived.Increment()
 
+	if !e.multicastSourceAllowed(pkt.NICID, id.LocalAddress, id.RemoteAddress) {
+		return
+	}
+
 	e.rcvMu.Lock()
 	// Drop the packet if our buffer is currently full.
 	if !e.rcvReady || e.rcvClosed {
@@ -1317,11 +1643,18 @@ func (e *endpoint) HandlePacket(id stack.TransportEndpointID, pkt *stack.PacketB
 	e.rcvBufSize += pkt.Data.Size()
 
 	// Save any useful information from the network header to the packet.
+	packet.netProto = pkt.NetworkProtocolNumber
 	switch pkt.NetworkProtocolNumber {
 	case header.IPv4ProtocolNumber:
-		packet.tos, _ = header.IPv4(pkt.NetworkHeader().View()).TOS()
+		h := header.IPv4(pkt.NetworkHeader().View())
+		packet.tos, _ = h.TOS()
+		packet.ttl = h.TTL()
 	case header.IPv6ProtocolNumber:
-		packet.tos, _ = header.IPv6(pkt.NetworkHeader().View()).TOS()
+		h := header.IPv6(pkt.NetworkHeader().View())
+		var flowLabel uint32
+		packet.tos, flowLabel = h.TOS()
+		packet.flowInfo = uint32(packet.tos)<<20 | flowLabel
+		packet.ttl = h.HopLimit()
 	}
 
 	// TODO(gvisor.dev/issue/3556): r.LocalAddress may be a multicast or broadcast
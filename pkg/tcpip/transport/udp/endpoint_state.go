@@ -92,8 +92,9 @@ func (e *endpoint) Resume(s *stack.Stack) {
 
 	e.stack = s
 
-	for m := range e.multicastMemberships {
-		if err := e.stack.JoinGroup(e.NetProto, m.nicID, m.multicastAddr); err != nil {
+	for m, filter := range e.multicastMemberships {
+		filter := filter
+		if err := e.stack.ChangeGroupSourceFilter(e.NetProto, m.nicID, m.multicastAddr, nil, &filter); err != nil {
 			panic(err)
 		}
 	}
@@ -115,7 +116,7 @@ func (e *endpoint) Resume(s *stack.Stack) {
 
 	var err *tcpip.Error
 	if state == StateConnected {
-		e.route, err = e.stack.FindRoute(e.RegisterNICID, e.ID.LocalAddress, e.ID.RemoteAddress, netProto, e.ops.GetMulticastLoop())
+		e.route, err = e.stack.FindRouteWithOptions(e.RegisterNICID, e.ID.LocalAddress, e.ID.RemoteAddress, netProto, e.ops.GetMulticastLoop(), stack.RouteLookupOptions{Mark: e.ops.GetMark(), Transparent: e.ops.GetTransparent()})
 		if err != nil {
 			panic(err)
 		}
@@ -894,6 +894,69 @@ func TestReadOnBoundToMulticast(t *testing.T) {
 	}
 }
 
+// TestReadOnSourceSpecificMulticast checks that an endpoint only receives
+// multicast packets from the sources allowed by the source filter of its
+// group membership.
+func TestReadOnSourceSpecificMulticast(t *testing.T) {
+	for _, flow := range []testFlow{multicastV4, multicastV6, multicastV6Only} {
+		t.Run(fmt.Sprintf("flow:%s", flow), func(t *testing.T) {
+			c := newDualTestContext(t, defaultMTU)
+			defer c.cleanup()
+
+			c.createEndpointForFlow(flow)
+
+			mcastAddr := flow.getMcastAddr()
+			if err := c.ep.Bind(tcpip.FullAddress{Addr: mcastAddr, Port: stackPort}); err != nil {
+				c.t.Fatal("Bind failed:", err)
+			}
+
+			srcAddr := flow.header4Tuple(incoming).srcAddr.Addr
+			otherSrcAddr := srcAddr[:len(srcAddr)-1] + "\x03"
+
+			setSockOpt := func(opt tcpip.SettableSocketOption, want *tcpip.Error) {
+				t.Helper()
+				if err := c.ep.SetSockOpt(opt); err != want {
+					t.Fatalf("got SetSockOpt(%#v) = %s, want = %s", opt, err, want)
+				}
+			}
+
+			// Join the group, receiving only packets from another source.
+			setSockOpt(&tcpip.AddSourceMembershipOption{NIC: 1, MulticastAddr: mcastAddr, SourceAddr: otherSrcAddr}, nil)
+			testFailingRead(c, flow, false /* expectReadError */)
+
+			setSockOpt(&tcpip.AddSourceMembershipOption{NIC: 1, MulticastAddr: mcastAddr, SourceAddr: srcAddr}, nil)
+			setSockOpt(&tcpip.AddSourceMembershipOption{NIC: 1, MulticastAddr: mcastAddr, SourceAddr: srcAddr}, tcpip.ErrPortInUse)
+			testRead(c, flow)
+
+			// A source-specific membership cannot block sources.
+			setSockOpt(&tcpip.BlockSourceOption{NIC: 1, MulticastAddr: mcastAddr, SourceAddr: srcAddr}, tcpip.ErrInvalidOptionValue)
+			setSockOpt(&tcpip.AddMembershipOption{NIC: 1, MulticastAddr: mcastAddr}, tcpip.ErrPortInUse)
+
+			// The group is left once the last source is dropped.
+			setSockOpt(&tcpip.DropSourceMembershipOption{NIC: 1, MulticastAddr: mcastAddr, SourceAddr: srcAddr}, nil)
+			setSockOpt(&tcpip.DropSourceMembershipOption{NIC: 1, MulticastAddr: mcastAddr, SourceAddr: srcAddr}, tcpip.ErrBadLocalAddress)
+			testFailingRead(c, flow, false /* expectReadError */)
+			setSockOpt(&tcpip.DropSourceMembershipOption{NIC: 1, MulticastAddr: mcastAddr, SourceAddr: otherSrcAddr}, nil)
+			if got, err := c.s.IsInGroup(1, mcastAddr); err != nil || got {
+				t.Fatalf("got c.s.IsInGroup(1, %s) = (%t, %s), want = (false, nil)", mcastAddr, got, err)
+			}
+
+			// Join the group receiving packets from all sources, then block
+			// and unblock the source.
+			setSockOpt(&tcpip.AddMembershipOption{NIC: 1, MulticastAddr: mcastAddr}, nil)
+			setSockOpt(&tcpip.AddSourceMembershipOption{NIC: 1, MulticastAddr: mcastAddr, SourceAddr: srcAddr}, tcpip.ErrInvalidOptionValue)
+			testRead(c, flow)
+
+			setSockOpt(&tcpip.BlockSourceOption{NIC: 1, MulticastAddr: mcastAddr, SourceAddr: srcAddr}, nil)
+			testFailingRead(c, flow, false /* expectReadError */)
+
+			setSockOpt(&tcpip.UnblockSourceOption{NIC: 1, MulticastAddr: mcastAddr, SourceAddr: srcAddr}, nil)
+			setSockOpt(&tcpip.UnblockSourceOption{NIC: 1, MulticastAddr: mcastAddr, SourceAddr: srcAddr}, tcpip.ErrBadLocalAddress)
+			testRead(c, flow)
+		})
+	}
+}
+
 // TestV4ReadOnBoundToBroadcast checks that an endpoint can bind to a broadcast
 // address and can receive only broadcast data.
 func TestV4ReadOnBoundToBroadcast(t *testing.T) {
@@ -2535,3 +2598,137 @@ func TestOutgoingSubnetBroadcast(t *testing.T) {
 		})
 	}
 }
+
+// newPayloadOfSize returns a random payload of exactly size bytes.
+func newPayloadOfSize(size int) []byte {
+	b := make([]byte, size)
+	for i := range b {
+		b[i] = byte(rand.Intn(256))
+	}
+	return b
+}
+
+func TestUDPSegmentOption(t *testing.T) {
+	const (
+		mtu     = 1500
+		gsoSize = 100
+		// maxSegments is the maximum number of datagrams a write may be
+		// split into.
+		maxSegments = 64
+	)
+	for _, flow := range []testFlow{unicastV4, unicastV6} {
+		t.Run(fmt.Sprintf("flow:%s", flow), func(t *testing.T) {
+			c := newDualTestContext(t, mtu)
+			defer c.cleanup()
+
+			c.createEndpointForFlow(flow)
+
+			if err := c.ep.SetSockOptInt(tcpip.UDPSegmentOption, gsoSize); err != nil {
+				c.t.Fatalf("SetSockOptInt(UDPSegmentOption, %d): %s", gsoSize, err)
+			}
+			if v, err := c.ep.GetSockOptInt(tcpip.UDPSegmentOption); err != nil || v != gsoSize {
+				c.t.Fatalf("got GetSockOptInt(UDPSegmentOption) = (%d, %s), want = (%d, nil)", v, err, gsoSize)
+			}
+
+			h := flow.header4Tuple(outgoing)
+			writeOpts := tcpip.WriteOptions{
+				To: &tcpip.FullAddress{Addr: flow.mapAddrIfApplicable(h.dstAddr.Addr), Port: h.dstAddr.Port},
+			}
+			payload := newPayloadOfSize(3*gsoSize + gsoSize/2)
+			var r bytes.Reader
+			r.Reset(payload)
+			if n, err := c.ep.Write(&r, writeOpts); err != nil || n != int64(len(payload)) {
+				c.t.Fatalf("got Write(_, _) = (%d, %s), want = (%d, nil)", n, err, len(payload))
+			}
+
+			for len(payload) > 0 {
+				want := payload
+				if len(want) > gsoSize {
+					want = want[:gsoSize]
+				}
+				payload = payload[len(want):]
+
+				b := c.getPacketAndVerify(flow)
+				var udp header.UDP
+				if flow.isV4() {
+					udp = header.UDP(header.IPv4(b).Payload())
+				} else {
+					udp = header.UDP(header.IPv6(b).Payload())
+				}
+				if !bytes.Equal(want, udp.Payload()) {
+					c.t.Fatalf("bad payload: got %x, want %x", udp.Payload(), want)
+				}
+			}
+			if got, want := c.s.Stats().UDP.PacketsSent.Value(), uint64(4); got != want {
+				c.t.Errorf("got PacketsSent = %d, want = %d", got, want)
+			}
+
+			// The payload may not be split into more than the maximum
+			// number of segments.
+			payload = newPayloadOfSize(gsoSize*maxSegments + 1)
+			r.Reset(payload)
+			if n, err := c.ep.Write(&r, writeOpts); err != tcpip.ErrInvalidOptionValue {
+				c.t.Fatalf("got Write(_, _) = (%d, %s), want = (_, %s)", n, err, tcpip.ErrInvalidOptionValue)
+			}
+
+			// The segment size may be overridden for a single write, and
+			// must fit in the MTU.
+			writeOpts.GSOSize = mtu
+			r.Reset(payload)
+			if n, err := c.ep.Write(&r, writeOpts); err != tcpip.ErrInvalidOptionValue {
+				c.t.Fatalf("got Write(_, %#v) = (%d, %s), want = (_, %s)", writeOpts, n, err, tcpip.ErrInvalidOptionValue)
+			}
+		})
+	}
+}
+
+func TestUDPGROOption(t *testing.T) {
+	const groSize = 100
+	c := newDualTestContext(t, defaultMTU)
+	defer c.cleanup()
+
+	c.createEndpointForFlow(unicastV4)
+
+	if err := c.ep.Bind(tcpip.FullAddress{Port: stackPort}); err != nil {
+		c.t.Fatalf("Bind failed: %s", err)
+	}
+	if err := c.ep.SetSockOptInt(tcpip.UDPGROOption, 1); err != nil {
+		c.t.Fatalf("SetSockOptInt(UDPGROOption, 1): %s", err)
+	}
+
+	// Three full sized datagrams followed by a shorter one are coalesced;
+	// the shorter one ends the batch.
+	var want []byte
+	for _, size := range []int{groSize, groSize, groSize, groSize / 2} {
+		payload := newPayloadOfSize(size)
+		want = append(want, payload...)
+		c.injectPacket(unicastV4, payload, false)
+	}
+	last := newPayloadOfSize(groSize)
+	c.injectPacket(unicastV4, last, false)
+
+	var buf bytes.Buffer
+	res, err := c.ep.Read(&buf, tcpip.ReadOptions{})
+	if err != nil {
+		c.t.Fatalf("Read failed: %s", err)
+	}
+	if !bytes.Equal(buf.Bytes(), want) {
+		c.t.Fatalf("got payload = %x, want = %x", buf.Bytes(), want)
+	}
+	if !res.ControlMessages.HasGROSize || res.ControlMessages.GROSize != groSize {
+		c.t.Fatalf("got (HasGROSize, GROSize) = (%t, %d), want = (true, %d)", res.ControlMessages.HasGROSize, res.ControlMessages.GROSize, groSize)
+	}
+
+	// A lone datagram is not reported as coalesced.
+	buf.Reset()
+	res, err = c.ep.Read(&buf, tcpip.ReadOptions{})
+	if err != nil {
+		c.t.Fatalf("Read failed: %s", err)
+	}
+	if !bytes.Equal(buf.Bytes(), last) {
+		c.t.Fatalf("got payload = %x, want = %x", buf.Bytes(), last)
+	}
+	if res.ControlMessages.HasGROSize {
+		c.t.Fatalf("got HasGROSize = true, want = false")
+	}
+}
@@ -0,0 +1,285 @@
+// Copyright 2021 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package vhostuser implements the frontend (driver) side of the vhost-user
+// protocol, which is used to drive virtio devices implemented by a backend
+// process, such as virtiofsd, through virtqueues in shared memory.
+//
+// See https://qemu-project.gitlab.io/qemu/interop/vhost-user.html and the
+// virtio specification for details.
+package vhostuser
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+
+	"gvisor.dev/gvisor/pkg/sync"
+	"gvisor.dev/gvisor/pkg/unet"
+)
+
+// Request types, from the vhost-user specification.
+const (
+	getFeatures         = 1
+	setFeatures         = 2
+	setOwner            = 3
+	setMemTable         = 5
+	setVringNum         = 8
+	setVringAddr        = 9
+	setVringBase        = 10
+	setVringKick        = 12
+	setVringCall        = 13
+	getProtocolFeatures = 15
+	setProtocolFeatures = 16
+	setVringEnable      = 18
+)
+
+// Message header flags.
+const (
+	flagVersion = 0x1
+	flagReply   = 0x4
+)
+
+// Feature bits.
+const (
+	// FeatureProtocolFeatures is VHOST_USER_F_PROTOCOL_FEATURES. If it is
+	// negotiated, queues are started disabled and must be enabled
+	// explicitly.
+	FeatureProtocolFeatures = 1 << 30
+
+	// FeatureVersion1 is VIRTIO_F_VERSION_1. It is required, since Queue
+	// only implements the little-endian (modern) queue layout.
+	FeatureVersion1 = 1 << 32
+)
+
+// hdrSize is the size of a message header: request, flags and size, each a
+// uint32.
+const hdrSize = 12
+
+// maxPayloadSize bounds the payload size of replies. Replies to the requests
+// sent by Frontend carry a single uint64.
+const maxPayloadSize = 8
+
+// Frontend is a vhost-user frontend connected to a backend.
+type Frontend struct {
+	// mu serializes requests on sock.
+	mu sync.Mutex
+
+	// sock is the connection to the backend.
+	sock *unet.Socket
+
+	// features are the negotiated feature bits. features is immutable.
+	features uint64
+}
+
+// NewFrontend negotiates features with the backend connected to sock, and
+// returns a Frontend that owns sock. want are the device-specific feature
+// bits that the caller supports; FeatureVersion1 is always required.
+func NewFrontend(sock *unet.Socket, want uint64) (*Frontend, error) {
+	f := &Frontend{sock: sock}
+	offered, err := f.getUint64(getFeatures)
+	if err != nil {
+		return nil, fmt.Errorf("getting features: %v", err)
+	}
+	if offered&FeatureVersion1 == 0 {
+		return nil, fmt.Errorf("backend doesn't offer VIRTIO_F_VERSION_1, features %#x", offered)
+	}
+	f.features = offered & (want | FeatureVersion1 | FeatureProtocolFeatures)
+	if f.features&FeatureProtocolFeatures != 0 {
+		// None of the protocol features are needed, but they must be
+		// negotiated before any other request is sent.
+		if _, err := f.getUint64(getProtocolFeatures); err != nil {
+			return nil, fmt.Errorf("getting protocol features: %v", err)
+		}
+		if err := f.setUint64(setProtocolFeatures, 0); err != nil {
+			return nil, fmt.Errorf("setting protocol features: %v", err)
+		}
+	}
+	if err := f.send(setOwner, nil); err != nil {
+		return nil, fmt.Errorf("setting owner: %v", err)
+	}
+	if err := f.setUint64(setFeatures, f.features); err != nil {
+		return nil, fmt.Errorf("setting features: %v", err)
+	}
+	return f, nil
+}
+
+// Features returns the negotiated feature bits.
+func (f *Frontend) Features() uint64 {
+	return f.features
+}
+
+// Close closes the connection to the backend. The backend stops processing
+// queues when the connection is closed.
+func (f *Frontend) Close() error {
+	return f.sock.Close()
+}
+
+// SetMemTable shares mem with the backend. Addresses in mem are used as
+// guest physical addresses, i.e. offset 0 in mem is guest physical address
+// 0.
+func (f *Frontend) SetMemTable(mem *Memory) error {
+	// struct VhostUserMemory with a single VhostUserMemoryRegion.
+	buf := make([]byte, 8+32)
+	binary.LittleEndian.PutUint32(buf[0:], 1)                   // nregions
+	binary.LittleEndian.PutUint64(buf[8:], 0)                   // guest_phys_addr
+	binary.LittleEndian.PutUint64(buf[16:], uint64(mem.Size())) // memory_size
+	binary.LittleEndian.PutUint64(buf[24:], mem.userAddr(0))    // userspace_addr
+	binary.LittleEndian.PutUint64(buf[32:], 0)                  // mmap_offset
+	return f.send(setMemTable, buf, mem.FD())
+}
+
+// SetupQueue configures the queue with the given index to use q, kickFD and
+// callFD, and enables it. kickFD and callFD are eventfds: the frontend writes
+// to kickFD when it makes buffers available in q, and the backend writes to
+// callFD when it marks buffers used.
+//
+// Preconditions: SetMemTable has been called with q's memory.
+func (f *Frontend) SetupQueue(index uint32, q *Queue, kickFD, callFD int) error {
+	if err := f.send(setVringNum, vringState(index, uint32(q.size))); err != nil {
+		return fmt.Errorf("setting queue %d size: %v", index, err)
+	}
+	if err := f.send(setVringBase, vringState(index, 0)); err != nil {
+		return fmt.Errorf("setting queue %d base: %v", index, err)
+	}
+	// struct vhost_vring_addr. Addresses are frontend virtual addresses.
+	buf := make([]byte, 40)
+	binary.LittleEndian.PutUint32(buf[0:], index)
+	binary.LittleEndian.PutUint64(buf[8:], q.mem.userAddr(q.descOff))
+	binary.LittleEndian.PutUint64(buf[16:], q.mem.userAddr(q.usedOff))
+	binary.LittleEndian.PutUint64(buf[24:], q.mem.userAddr(q.availOff))
+	if err := f.send(setVringAddr, buf); err != nil {
+		return fmt.Errorf("setting queue %d addresses: %v", index, err)
+	}
+	if err := f.setUint64(setVringCall, uint64(index), callFD); err != nil {
+		return fmt.Errorf("setting queue %d call eventfd: %v", index, err)
+	}
+	if err := f.setUint64(setVringKick, uint64(index), kickFD); err != nil {
+		return fmt.Errorf("setting queue %d kick eventfd: %v", index, err)
+	}
+	if f.features&FeatureProtocolFeatures != 0 {
+		if err := f.send(setVringEnable, vringState(index, 1)); err != nil {
+			return fmt.Errorf("enabling queue %d: %v", index, err)
+		}
+	}
+	return nil
+}
+
+// vringState returns a struct vhost_vring_state.
+func vringState(index, num uint32) []byte {
+	buf := make([]byte, 8)
+	binary.LittleEndian.PutUint32(buf[0:], index)
+	binary.LittleEndian.PutUint32(buf[4:], num)
+	return buf
+}
+
+// setUint64 sends a request with a uint64 payload.
+func (f *Frontend) setUint64(req uint32, val uint64, fds ...int) error {
+	buf := make([]byte, 8)
+	binary.LittleEndian.PutUint64(buf, val)
+	return f.send(req, buf, fds...)
+}
+
+// getUint64 sends a request without payload, and returns the uint64 payload
+// of the reply.
+func (f *Frontend) getUint64(req uint32) (uint64, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.sendLocked(req, nil); err != nil {
+		return 0, err
+	}
+	payload, err := f.recvReplyLocked(req)
+	if err != nil {
+		return 0, err
+	}
+	if len(payload) != 8 {
+		return 0, fmt.Errorf("reply to request %d has payload size %d, want 8", req, len(payload))
+	}
+	return binary.LittleEndian.Uint64(payload), nil
+}
+
+// send sends a request that has no reply.
+func (f *Frontend) send(req uint32, payload []byte, fds ...int) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.sendLocked(req, payload, fds...)
+}
+
+// sendLocked sends a request, passing fds along with it.
+//
+// Preconditions: f.mu must be locked.
+func (f *Frontend) sendLocked(req uint32, payload []byte, fds ...int) error {
+	msg := make([]byte, hdrSize+len(payload))
+	binary.LittleEndian.PutUint32(msg[0:], req)
+	binary.LittleEndian.PutUint32(msg[4:], flagVersion)
+	binary.LittleEndian.PutUint32(msg[8:], uint32(len(payload)))
+	copy(msg[hdrSize:], payload)
+
+	w := f.sock.Writer(true)
+	if len(fds) != 0 {
+		w.PackFDs(fds...)
+	}
+	for len(msg) > 0 {
+		n, err := w.WriteVec([][]byte{msg})
+		if err != nil {
+			return err
+		}
+		msg = msg[n:]
+		// FDs are sent with the first byte of the message only.
+		w.UnpackFDs()
+	}
+	return nil
+}
+
+// recvReplyLocked receives the reply to a request and returns its payload.
+//
+// Preconditions: f.mu must be locked.
+func (f *Frontend) recvReplyLocked(req uint32) ([]byte, error) {
+	hdr := make([]byte, hdrSize)
+	if err := f.readFullLocked(hdr); err != nil {
+		return nil, err
+	}
+	gotReq := binary.LittleEndian.Uint32(hdr[0:])
+	flags := binary.LittleEndian.Uint32(hdr[4:])
+	size := binary.LittleEndian.Uint32(hdr[8:])
+	if gotReq != req || flags&flagReply == 0 {
+		return nil, fmt.Errorf("got message %d with flags %#x, want reply to request %d", gotReq, flags, req)
+	}
+	if size > maxPayloadSize {
+		return nil, fmt.Errorf("reply to request %d has payload size %d, want at most %d", req, size, maxPayloadSize)
+	}
+	payload := make([]byte, size)
+	if err := f.readFullLocked(payload); err != nil {
+		return nil, err
+	}
+	return payload, nil
+}
+
+// readFullLocked reads exactly len(buf) bytes from f.sock.
+//
+// Preconditions: f.mu must be locked.
+func (f *Frontend) readFullLocked(buf []byte) error {
+	r := f.sock.Reader(true)
+	for len(buf) > 0 {
+		n, err := r.ReadVec([][]byte{buf})
+		if err != nil {
+			if err == io.EOF {
+				return io.ErrUnexpectedEOF
+			}
+			return err
+		}
+		buf = buf[n:]
+	}
+	return nil
+}
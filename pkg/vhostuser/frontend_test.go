@@ -0,0 +1,229 @@
+// Copyright 2021 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vhostuser
+
+import (
+	"encoding/binary"
+	"syscall"
+	"testing"
+
+	"gvisor.dev/gvisor/pkg/unet"
+)
+
+// message is a request received by backend.
+type message struct {
+	req     uint32
+	payload []byte
+	numFDs  int
+}
+
+// backend is a fake vhost-user backend offering the given features.
+type backend struct {
+	sock     *unet.Socket
+	features uint64
+	msgs     chan message
+}
+
+func (b *backend) reply(req uint32, val uint64) error {
+	buf := make([]byte, hdrSize+8)
+	binary.LittleEndian.PutUint32(buf[0:], req)
+	binary.LittleEndian.PutUint32(buf[4:], flagVersion|flagReply)
+	binary.LittleEndian.PutUint32(buf[8:], 8)
+	binary.LittleEndian.PutUint64(buf[hdrSize:], val)
+	_, err := b.sock.Write(buf)
+	return err
+}
+
+// readFull reads exactly len(buf) bytes, and returns the number of FDs
+// received with them.
+func (b *backend) readFull(buf []byte) (int, error) {
+	numFDs := 0
+	for len(buf) > 0 {
+		r := b.sock.Reader(true)
+		r.EnableFDs(4)
+		n, err := r.ReadVec([][]byte{buf})
+		if err != nil {
+			return 0, err
+		}
+		fds, _ := r.ExtractFDs()
+		for _, fd := range fds {
+			syscall.Close(fd)
+		}
+		numFDs += len(fds)
+		buf = buf[n:]
+	}
+	return numFDs, nil
+}
+
+// serve receives requests until the connection is closed.
+func (b *backend) serve() {
+	defer close(b.msgs)
+	for {
+		hdr := make([]byte, hdrSize)
+		numFDs, err := b.readFull(hdr)
+		if err != nil {
+			return
+		}
+		req := binary.LittleEndian.Uint32(hdr[0:])
+		payload := make([]byte, binary.LittleEndian.Uint32(hdr[8:]))
+		if _, err := b.readFull(payload); err != nil {
+			return
+		}
+		b.msgs <- message{req: req, payload: payload, numFDs: numFDs}
+		switch req {
+		case getFeatures:
+			err = b.reply(req, b.features)
+		case getProtocolFeatures:
+			err = b.reply(req, 0xff)
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+func newBackend(t *testing.T, features uint64) (*backend, *unet.Socket) {
+	backendSocket, frontendSocket, err := unet.SocketPair(false)
+	if err != nil {
+		t.Fatalf("socketpair got err %v expected nil", err)
+	}
+	b := &backend{
+		sock:     backendSocket,
+		features: features,
+		msgs:     make(chan message, 100),
+	}
+	go b.serve()
+	return b, frontendSocket
+}
+
+// requests returns the requests received by b once the frontend has closed
+// the connection.
+func (b *backend) requests() []message {
+	var msgs []message
+	for msg := range b.msgs {
+		msgs = append(msgs, msg)
+	}
+	b.sock.Close()
+	return msgs
+}
+
+func TestNewFrontend(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		features uint64
+		want     uint64
+		wantReqs []uint32
+	}{
+		{
+			name:     "protocol features",
+			features: FeatureVersion1 | FeatureProtocolFeatures | 1<<0 | 1<<1,
+			want:     FeatureVersion1 | FeatureProtocolFeatures | 1<<0,
+			wantReqs: []uint32{getFeatures, getProtocolFeatures, setProtocolFeatures, setOwner, setFeatures},
+		},
+		{
+			name:     "no protocol features",
+			features: FeatureVersion1,
+			want:     FeatureVersion1,
+			wantReqs: []uint32{getFeatures, setOwner, setFeatures},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			b, sock := newBackend(t, tc.features)
+			f, err := NewFrontend(sock, 1<<0)
+			if err != nil {
+				t.Fatalf("NewFrontend failed: %v", err)
+			}
+			if got := f.Features(); got != tc.want {
+				t.Errorf("got features %#x, want %#x", got, tc.want)
+			}
+			f.Close()
+
+			msgs := b.requests()
+			if len(msgs) != len(tc.wantReqs) {
+				t.Fatalf("got %d requests %+v, want %v", len(msgs), msgs, tc.wantReqs)
+			}
+			for i, msg := range msgs {
+				if msg.req != tc.wantReqs[i] {
+					t.Errorf("request %d got %d, want %d", i, msg.req, tc.wantReqs[i])
+				}
+			}
+			if last := msgs[len(msgs)-1]; binary.LittleEndian.Uint64(last.payload) != tc.want {
+				t.Errorf("set features to %#x, want %#x", binary.LittleEndian.Uint64(last.payload), tc.want)
+			}
+		})
+	}
+}
+
+func TestNewFrontendNoVersion1(t *testing.T) {
+	b, sock := newBackend(t, FeatureProtocolFeatures)
+	if _, err := NewFrontend(sock, 0); err == nil {
+		t.Errorf("NewFrontend succeeded without VIRTIO_F_VERSION_1")
+	}
+	sock.Close()
+	b.requests()
+}
+
+func TestSetupQueue(t *testing.T) {
+	b, sock := newBackend(t, FeatureVersion1|FeatureProtocolFeatures)
+	f, err := NewFrontend(sock, 0)
+	if err != nil {
+		t.Fatalf("NewFrontend failed: %v", err)
+	}
+	mem, err := NewMemory("vhostuser-test", int(QueueBytes(8)))
+	if err != nil {
+		t.Fatalf("NewMemory failed: %v", err)
+	}
+	defer mem.Release()
+	q, err := NewQueue(mem, 0, 8)
+	if err != nil {
+		t.Fatalf("NewQueue failed: %v", err)
+	}
+	if err := f.SetMemTable(mem); err != nil {
+		t.Fatalf("SetMemTable failed: %v", err)
+	}
+	if err := f.SetupQueue(1, q, mem.FD(), mem.FD()); err != nil {
+		t.Fatalf("SetupQueue failed: %v", err)
+	}
+	f.Close()
+
+	// Skip the feature negotiation.
+	msgs := b.requests()[5:]
+	want := []struct {
+		req    uint32
+		numFDs int
+	}{
+		{setMemTable, 1},
+		{setVringNum, 0},
+		{setVringBase, 0},
+		{setVringAddr, 0},
+		{setVringCall, 1},
+		{setVringKick, 1},
+		{setVringEnable, 0},
+	}
+	if len(msgs) != len(want) {
+		t.Fatalf("got %d requests %+v, want %d", len(msgs), msgs, len(want))
+	}
+	for i, msg := range msgs {
+		if msg.req != want[i].req || msg.numFDs != want[i].numFDs {
+			t.Errorf("request %d got %d with %d FDs, want %d with %d FDs", i, msg.req, msg.numFDs, want[i].req, want[i].numFDs)
+		}
+		if msg.req != setMemTable && binary.LittleEndian.Uint32(msg.payload) != 1 {
+			t.Errorf("request %d is for queue %d, want 1", i, binary.LittleEndian.Uint32(msg.payload))
+		}
+	}
+	if got, want := binary.LittleEndian.Uint64(msgs[1].payload), uint64(8)<<32|1; got != want {
+		t.Errorf("set queue size got payload %#x, want %#x", got, want)
+	}
+}
@@ -0,0 +1,76 @@
+// Copyright 2021 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vhostuser
+
+import (
+	"fmt"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+	"gvisor.dev/gvisor/pkg/memutil"
+)
+
+// Memory is a memfd mapped in the frontend, which is shared with the backend
+// by Frontend.SetMemTable. Queues and the buffers they refer to are allocated
+// by the user of Memory; addresses in Memory are offsets from its start.
+type Memory struct {
+	// fd is the memfd. fd is immutable.
+	fd int
+
+	// data is the frontend's mapping of fd. data is immutable.
+	data []byte
+}
+
+// NewMemory returns a new Memory of the given size, which must be a multiple
+// of the page size. name is used to name the memfd.
+func NewMemory(name string, size int) (*Memory, error) {
+	fd, err := memutil.CreateMemFD(name, unix.MFD_CLOEXEC)
+	if err != nil {
+		return nil, fmt.Errorf("creating memfd: %v", err)
+	}
+	if err := syscall.Ftruncate(fd, int64(size)); err != nil {
+		syscall.Close(fd)
+		return nil, fmt.Errorf("truncating memfd to %d bytes: %v", size, err)
+	}
+	data, err := syscall.Mmap(fd, 0, size, syscall.PROT_READ|syscall.PROT_WRITE, syscall.MAP_SHARED)
+	if err != nil {
+		syscall.Close(fd)
+		return nil, fmt.Errorf("mapping memfd: %v", err)
+	}
+	return &Memory{fd: fd, data: data}, nil
+}
+
+// FD returns the memfd.
+func (m *Memory) FD() int {
+	return m.fd
+}
+
+// Size returns the size of m in bytes.
+func (m *Memory) Size() int {
+	return len(m.data)
+}
+
+// Slice returns the n bytes of m at addr.
+func (m *Memory) Slice(addr uint64, n uint32) []byte {
+	return m.data[addr : addr+uint64(n)]
+}
+
+// Release unmaps m and closes its memfd. The backend keeps its own mapping.
+//
+// Preconditions: m and slices of it are no longer used.
+func (m *Memory) Release() {
+	syscall.Munmap(m.data)
+	syscall.Close(m.fd)
+}
@@ -0,0 +1,43 @@
+// Copyright 2021 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vhostuser
+
+import (
+	"sync/atomic"
+	"unsafe"
+)
+
+// userAddr returns the frontend virtual address of the given offset in m.
+func (m *Memory) userAddr(off uint64) uint64 {
+	return uint64(uintptr(unsafe.Pointer(&m.data[0]))) + off
+}
+
+// loadUint16 atomically loads the little-endian uint16 at off in m. The
+// containing, 4-byte aligned uint32 is loaded, since there are no 16-bit
+// atomic operations; this relies on the host being little-endian, as all
+// supported hosts are.
+//
+// Preconditions: off is 2-byte aligned.
+func (m *Memory) loadUint16(off uint64) uint16 {
+	v := atomic.LoadUint32((*uint32)(unsafe.Pointer(&m.data[off&^3])))
+	return uint16(v >> ((off & 3) * 8))
+}
+
+// storeUint32 atomically stores the little-endian uint32 v at off in m.
+//
+// Preconditions: off is 4-byte aligned.
+func (m *Memory) storeUint32(off uint64, v uint32) {
+	atomic.StoreUint32((*uint32)(unsafe.Pointer(&m.data[off])), v)
+}
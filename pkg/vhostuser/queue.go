@@ -0,0 +1,218 @@
+// Copyright 2021 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vhostuser
+
+import (
+	"encoding/binary"
+	"fmt"
+	"syscall"
+
+	"gvisor.dev/gvisor/pkg/sync"
+)
+
+// Split virtqueue layout, from the virtio specification:
+//
+// - The descriptor table has one 16-byte entry per descriptor: addr uint64,
+// len uint32, flags uint16, next uint16.
+//
+// - The available ring, written by the driver, is flags uint16, idx uint16,
+// ring [size]uint16 and used_event uint16.
+//
+// - The used ring, written by the device, is flags uint16, idx uint16,
+// ring [size]{id uint32, len uint32} and avail_event uint16.
+//
+// Each part is page-aligned in Memory, which satisfies the virtio alignment
+// requirements and makes the idx fields 4-byte aligned.
+const (
+	descSize = 16
+
+	descFlagNext  = 1
+	descFlagWrite = 2
+
+	queuePageSize = 4096
+)
+
+// MaxQueueSize is the maximum number of descriptors in a Queue.
+const MaxQueueSize = 32768
+
+// Buffer is a buffer in Memory that is part of a descriptor chain.
+type Buffer struct {
+	// Addr is the offset of the buffer in Memory.
+	Addr uint64
+
+	// Len is the length of the buffer in bytes.
+	Len uint32
+
+	// Write is true if the buffer is written by the device, rather than
+	// read by it.
+	Write bool
+}
+
+// Queue is the driver side of a split virtqueue.
+type Queue struct {
+	// mem holds the queue. mem is immutable.
+	mem *Memory
+
+	// size is the number of descriptors in the queue. size is immutable.
+	size uint16
+
+	// descOff, availOff and usedOff are the offsets of the descriptor
+	// table, available ring and used ring in mem. They are immutable.
+	descOff  uint64
+	availOff uint64
+	usedOff  uint64
+
+	// mu protects the following fields.
+	mu sync.Mutex
+
+	// freeHead is the first descriptor of the free list, which is linked
+	// through the next fields of the descriptors.
+	freeHead uint16
+
+	// numFree is the number of descriptors in the free list.
+	numFree uint16
+
+	// availIdx is the driver's copy of the available ring's idx.
+	availIdx uint16
+
+	// usedIdx is the used ring's idx up to which used buffers have been
+	// returned by PopUsed.
+	usedIdx uint16
+}
+
+func roundUpToPage(n uint64) uint64 {
+	return (n + queuePageSize - 1) &^ (queuePageSize - 1)
+}
+
+// QueueBytes returns the number of bytes of Memory used by a Queue with the
+// given size. It is a multiple of the page size.
+func QueueBytes(size uint16) uint64 {
+	desc := roundUpToPage(uint64(size) * descSize)
+	avail := roundUpToPage(6 + 2*uint64(size))
+	used := roundUpToPage(6 + 8*uint64(size))
+	return desc + avail + used
+}
+
+// NewQueue returns a Queue with the given number of descriptors, which must
+// be a power of 2, stored in mem at the page-aligned offset off. The
+// QueueBytes(size) bytes of mem at off must be zeroed.
+func NewQueue(mem *Memory, off uint64, size uint16) (*Queue, error) {
+	if size == 0 || size&(size-1) != 0 || size > MaxQueueSize {
+		return nil, fmt.Errorf("invalid queue size %d", size)
+	}
+	if off%queuePageSize != 0 || off+QueueBytes(size) > uint64(mem.Size()) {
+		return nil, fmt.Errorf("invalid queue offset %#x for memory of size %#x", off, mem.Size())
+	}
+	q := &Queue{
+		mem:     mem,
+		size:    size,
+		descOff: off,
+		numFree: size,
+	}
+	q.availOff = q.descOff + roundUpToPage(uint64(size)*descSize)
+	q.usedOff = q.availOff + roundUpToPage(6+2*uint64(size))
+	for i := uint16(0); i < size-1; i++ {
+		binary.LittleEndian.PutUint16(q.desc(i)[14:], i+1)
+	}
+	return q, nil
+}
+
+// Size returns the number of descriptors in q.
+func (q *Queue) Size() uint16 {
+	return q.size
+}
+
+// desc returns the descriptor with the given index.
+func (q *Queue) desc(i uint16) []byte {
+	return q.mem.Slice(q.descOff+uint64(i)*descSize, descSize)
+}
+
+// Add makes a descriptor chain of bufs available to the device, and returns
+// the index of its head. Device-readable buffers must precede
+// device-writable buffers. The device must be notified separately. Add
+// returns ENOSPC if there are not enough free descriptors.
+func (q *Queue) Add(bufs ...Buffer) (uint16, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if len(bufs) == 0 {
+		return 0, syscall.EINVAL
+	}
+	if len(bufs) > int(q.numFree) {
+		return 0, syscall.ENOSPC
+	}
+
+	head := q.freeHead
+	i := head
+	for j, buf := range bufs {
+		d := q.desc(i)
+		next := binary.LittleEndian.Uint16(d[14:])
+		var flags uint16
+		if buf.Write {
+			flags |= descFlagWrite
+		}
+		if j != len(bufs)-1 {
+			flags |= descFlagNext
+		}
+		binary.LittleEndian.PutUint64(d[0:], buf.Addr)
+		binary.LittleEndian.PutUint32(d[8:], buf.Len)
+		binary.LittleEndian.PutUint16(d[12:], flags)
+		if j != len(bufs)-1 {
+			i = next
+		} else {
+			q.freeHead = next
+		}
+	}
+	q.numFree -= uint16(len(bufs))
+
+	// Publish the chain. The atomic store of idx orders it after the
+	// descriptor and ring writes above. The available ring's flags are
+	// always 0, since the driver always wants to be notified.
+	binary.LittleEndian.PutUint16(q.mem.Slice(q.availOff+4+2*uint64(q.availIdx%q.size), 2), head)
+	q.availIdx++
+	q.mem.storeUint32(q.availOff, uint32(q.availIdx)<<16)
+	return head, nil
+}
+
+// PopUsed returns the head of a descriptor chain that the device has
+// finished with, and the number of bytes the device wrote to its
+// device-writable buffers. The chain's descriptors are freed. ok is false if
+// there are no used chains.
+func (q *Queue) PopUsed() (head uint16, written uint32, ok bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.mem.loadUint16(q.usedOff+2) == q.usedIdx {
+		return 0, 0, false
+	}
+	elem := q.mem.Slice(q.usedOff+4+8*uint64(q.usedIdx%q.size), 8)
+	head = uint16(binary.LittleEndian.Uint32(elem[0:]))
+	written = binary.LittleEndian.Uint32(elem[4:])
+	q.usedIdx++
+
+	// Return the chain to the free list.
+	i := head
+	n := uint16(1)
+	for {
+		d := q.desc(i)
+		if binary.LittleEndian.Uint16(d[12:])&descFlagNext == 0 {
+			binary.LittleEndian.PutUint16(d[14:], q.freeHead)
+			break
+		}
+		i = binary.LittleEndian.Uint16(d[14:])
+		n++
+	}
+	q.freeHead = head
+	q.numFree += n
+	return head, written, true
+}
@@ -0,0 +1,141 @@
+// Copyright 2021 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vhostuser
+
+import (
+	"encoding/binary"
+	"syscall"
+	"testing"
+)
+
+// device consumes available descriptor chains of a Queue, as a backend
+// would.
+type device struct {
+	q        *Queue
+	availIdx uint16
+	usedIdx  uint16
+}
+
+// pop returns the buffers of the next available chain and its head.
+func (d *device) pop() (uint16, []Buffer, bool) {
+	q := d.q
+	if q.mem.loadUint16(q.availOff+2) == d.availIdx {
+		return 0, nil, false
+	}
+	head := binary.LittleEndian.Uint16(q.mem.Slice(q.availOff+4+2*uint64(d.availIdx%q.size), 2))
+	d.availIdx++
+	var bufs []Buffer
+	for i := head; ; {
+		desc := q.desc(i)
+		flags := binary.LittleEndian.Uint16(desc[12:])
+		bufs = append(bufs, Buffer{
+			Addr:  binary.LittleEndian.Uint64(desc[0:]),
+			Len:   binary.LittleEndian.Uint32(desc[8:]),
+			Write: flags&descFlagWrite != 0,
+		})
+		if flags&descFlagNext == 0 {
+			break
+		}
+		i = binary.LittleEndian.Uint16(desc[14:])
+	}
+	return head, bufs, true
+}
+
+// push marks the chain with the given head used.
+func (d *device) push(head uint16, written uint32) {
+	q := d.q
+	elem := q.mem.Slice(q.usedOff+4+8*uint64(d.usedIdx%q.size), 8)
+	binary.LittleEndian.PutUint32(elem[0:], uint32(head))
+	binary.LittleEndian.PutUint32(elem[4:], written)
+	d.usedIdx++
+	q.mem.storeUint32(q.usedOff, uint32(d.usedIdx)<<16)
+}
+
+func TestQueue(t *testing.T) {
+	const size = 4
+	mem, err := NewMemory("vhostuser-test", int(QueueBytes(size)))
+	if err != nil {
+		t.Fatalf("NewMemory failed: %v", err)
+	}
+	defer mem.Release()
+	q, err := NewQueue(mem, 0, size)
+	if err != nil {
+		t.Fatalf("NewQueue failed: %v", err)
+	}
+	d := device{q: q}
+
+	// Cycle through the ring more than once.
+	for i := 0; i < 3*size; i++ {
+		want := []Buffer{
+			{Addr: 0x1000, Len: 100},
+			{Addr: 0x2000, Len: 200, Write: true},
+		}
+		head, err := q.Add(want...)
+		if err != nil {
+			t.Fatalf("Add failed: %v", err)
+		}
+		if _, _, ok := q.PopUsed(); ok {
+			t.Fatalf("PopUsed returned a chain before the device used it")
+		}
+
+		gotHead, got, ok := d.pop()
+		if !ok {
+			t.Fatalf("device found no available chain")
+		}
+		if gotHead != head || len(got) != len(want) || got[0] != want[0] || got[1] != want[1] {
+			t.Fatalf("device got chain %d %+v, want %d %+v", gotHead, got, head, want)
+		}
+		d.push(head, 50)
+
+		usedHead, written, ok := q.PopUsed()
+		if !ok || usedHead != head || written != 50 {
+			t.Fatalf("PopUsed got (%d, %d, %t), want (%d, 50, true)", usedHead, written, ok, head)
+		}
+	}
+
+	// All descriptors are free again.
+	for i := 0; i < size/2; i++ {
+		if _, err := q.Add(Buffer{Addr: 0x1000, Len: 1}, Buffer{Addr: 0x2000, Len: 1, Write: true}); err != nil {
+			t.Fatalf("Add failed: %v", err)
+		}
+	}
+	if _, err := q.Add(Buffer{Addr: 0x1000, Len: 1}); err != syscall.ENOSPC {
+		t.Errorf("Add on a full queue got err %v, want %v", err, syscall.ENOSPC)
+	}
+}
+
+func TestNewQueueInvalid(t *testing.T) {
+	mem, err := NewMemory("vhostuser-test", int(QueueBytes(8)))
+	if err != nil {
+		t.Fatalf("NewMemory failed: %v", err)
+	}
+	defer mem.Release()
+	for _, tc := range []struct {
+		name string
+		off  uint64
+		size uint16
+	}{
+		{name: "zero size", size: 0},
+		{name: "not a power of 2", size: 6},
+		{name: "unaligned", off: 8, size: 4},
+		{name: "too large", size: 1024},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if _, err := NewQueue(mem, tc.off, tc.size); err == nil {
+				t.Errorf("NewQueue(%#x, %d) succeeded", tc.off, tc.size)
+			}
+		})
+	}
+}
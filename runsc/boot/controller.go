@@ -15,10 +15,14 @@
 package boot
 
 import (
+	"bytes"
 	"errors"
 	"fmt"
 	"os"
+	"runtime"
+	"sync/atomic"
 	"syscall"
+	gtime "time"
 
 	specs "github.com/opencontainers/runtime-spec/specs-go"
 	"gvisor.dev/gvisor/pkg/control/server"
@@ -30,6 +34,7 @@ import (
 	"gvisor.dev/gvisor/pkg/sentry/socket/netstack"
 	"gvisor.dev/gvisor/pkg/sentry/state"
 	"gvisor.dev/gvisor/pkg/sentry/time"
+	"gvisor.dev/gvisor/pkg/sentry/usage"
 	"gvisor.dev/gvisor/pkg/sentry/vfs"
 	"gvisor.dev/gvisor/pkg/sentry/watchdog"
 	"gvisor.dev/gvisor/pkg/tcpip/stack"
@@ -58,9 +63,32 @@ const (
 	// container.
 	ContainerExecuteAsync = "containerManager.ExecuteAsync"
 
+	// ContainerMigrate live-migrates the sandbox to another host.
+	ContainerMigrate = "containerManager.Migrate"
+
 	// ContainerPause pauses the container.
 	ContainerPause = "containerManager.Pause"
 
+	// ContainerFSStats is the URPC endpoint for getting the file operation
+	// statistics of a container's mounts.
+	ContainerFSStats = "containerManager.FSStats"
+
+	// ContainerSyscallStats is the URPC endpoint for getting the syscall
+	// latency histograms and the slowest recent syscalls of the sandbox.
+	ContainerSyscallStats = "containerManager.SyscallStats"
+
+	// ContainerMemoryBreakdown is the URPC endpoint for getting the
+	// attribution of the sandbox's memory usage.
+	ContainerMemoryBreakdown = "containerManager.MemoryBreakdown"
+
+	// ContainerStallEvents is the URPC endpoint for getting the stalls
+	// detected by the watchdog.
+	ContainerStallEvents = "containerManager.StallEvents"
+
+	// ContainerPrefetch is the URPC endpoint for reading files in a container
+	// ahead of their use.
+	ContainerPrefetch = "containerManager.Prefetch"
+
 	// ContainerProcesses is the URPC endpoint for getting the list of
 	// processes running in a container.
 	ContainerProcesses = "containerManager.Processes"
@@ -94,21 +122,30 @@ const (
 	// and routes in a network stack.
 	NetworkCreateLinksAndRoutes = "Network.CreateLinksAndRoutes"
 
+	// NetworkCapture is the URPC endpoint for capturing packets in a
+	// network stack.
+	NetworkCapture = "Network.Capture"
+
 	// RootContainerStart is the URPC endpoint for starting a new sandbox
 	// with root container.
 	RootContainerStart = "containerManager.StartRoot"
 
+	// SandboxResize is the URPC endpoint for changing the number of CPUs and
+	// the amount of memory visible to applications in the sandbox.
+	SandboxResize = "containerManager.Resize"
+
 	// SandboxStacks collects sandbox stacks for debugging.
 	SandboxStacks = "debug.Stacks"
 )
 
 // Profiling related commands (see pprof.go for more details).
 const (
-	CPUProfile   = "Profile.CPU"
-	HeapProfile  = "Profile.Heap"
-	BlockProfile = "Profile.Block"
-	MutexProfile = "Profile.Mutex"
-	Trace        = "Profile.Trace"
+	CPUProfile      = "Profile.CPU"
+	HeapProfile     = "Profile.Heap"
+	BlockProfile    = "Profile.Block"
+	MutexProfile    = "Profile.Mutex"
+	Trace           = "Profile.Trace"
+	ProfileRingDump = "Profile.RingDump"
 )
 
 // Logging related commands (see logging.go for more details).
@@ -116,6 +153,12 @@ const (
 	ChangeLogging = "Logging.Change"
 )
 
+// Verity related commands (see pkg/sentry/control/verity.go for more
+// details).
+const (
+	VerityMeasurements = "Verity.Measurements"
+)
+
 // ControlSocketAddr generates an abstract unix socket name for the given ID.
 func ControlSocketAddr(id string) string {
 	return fmt.Sprintf("\x00runsc-sandbox.%s", id)
@@ -149,17 +192,27 @@ func newController(fd int, l *Loader) (*controller, error) {
 	ctrl.srv.Register(ctrl.manager)
 
 	if eps, ok := l.k.RootNetworkNamespace().Stack().(*netstack.Stack); ok {
+		dnsStub, err := NewDNSStubConfig(l.root.conf)
+		if err != nil {
+			return nil, err
+		}
 		net := &Network{
-			Stack: eps.Stack,
+			Stack:   eps.Stack,
+			DNSStub: dnsStub,
 		}
 		ctrl.srv.Register(net)
 	}
 
 	ctrl.srv.Register(&debug{})
 	ctrl.srv.Register(&control.Logging{})
+	ctrl.srv.Register(&control.Verity{})
 
 	if l.root.conf.ProfileEnable {
-		ctrl.srv.Register(control.NewProfile(l.k))
+		profile := control.NewProfile(l.k)
+		if l.profileRing != nil {
+			profile.StartContinuous(l.profileRing)
+		}
+		ctrl.srv.Register(profile)
 	}
 
 	return ctrl, nil
@@ -201,6 +254,141 @@ func (cm *containerManager) Processes(cid *string, out *[]*control.Process) erro
 	return control.Processes(cm.l.k, *cid, out)
 }
 
+// PrefetchArgs contains arguments to the Prefetch method.
+type PrefetchArgs struct {
+	// CID is the ID of the container in which files are read.
+	CID string
+
+	// Paths are the absolute paths of the files to read in the container.
+	// Directories are read recursively.
+	Paths []string
+}
+
+// Prefetch reads files in a container, so that their contents are cached by
+// the time applications read them. It returns the number of files read.
+func (cm *containerManager) Prefetch(args *PrefetchArgs, files *int) error {
+	log.Debugf("containerManager.Prefetch, cid: %s, %d paths", args.CID, len(args.Paths))
+	n, err := cm.l.prefetch(args.CID, args.Paths)
+	*files = n
+	return err
+}
+
+// ResizeArgs contains arguments to the Resize method.
+type ResizeArgs struct {
+	// CPUs is the number of CPUs that are online in the sandbox, or 0 to
+	// leave it unchanged.
+	CPUs uint
+
+	// TotalMem is the total amount of memory, in bytes, reported to
+	// applications in the sandbox, or 0 to leave it unchanged.
+	TotalMem uint64
+
+	// ClampCPUs limits CPUs to the maximum number of CPUs of the sandbox,
+	// rather than failing if it is exceeded.
+	ClampCPUs bool
+}
+
+// Resize changes the number of CPUs and the amount of memory visible to
+// applications in the sandbox. The number of CPUs can't exceed the maximum
+// that the sandbox was started with.
+func (cm *containerManager) Resize(args *ResizeArgs, _ *struct{}) error {
+	log.Debugf("containerManager.Resize, cpus: %d, total memory: %d", args.CPUs, args.TotalMem)
+	if args.CPUs != 0 {
+		cpus := args.CPUs
+		if max := cm.l.k.ApplicationCores(); args.ClampCPUs && cpus > max {
+			log.Infof("Limiting online CPUs to the maximum of %d, instead of %d", max, cpus)
+			cpus = max
+		}
+		if err := cm.l.k.SetOnlineCores(cpus); err != nil {
+			return err
+		}
+		runtime.GOMAXPROCS(int(cpus))
+	}
+	if args.TotalMem != 0 {
+		atomic.StoreUint64(&usage.MaximumTotalMemoryBytes, args.TotalMem)
+		log.Infof("Setting total memory to %.2f GB", float64(args.TotalMem)/(1<<30))
+	}
+	return nil
+}
+
+// FSStats returns the file operation statistics of the mounts in a container's
+// mount namespace.
+func (cm *containerManager) FSStats(cid *string, out *string) error {
+	log.Debugf("containerManager.FSStats, cid: %s", *cid)
+	if !vfs.RecordMountStats {
+		return fmt.Errorf("file system statistics are not enabled; use --fs-stats")
+	}
+	tg, err := cm.l.threadGroupFromID(execID{cid: *cid})
+	if err != nil {
+		return err
+	}
+	// task.MountNamespaceVFS2() does not take a ref, so we must do so
+	// ourselves.
+	mns := tg.Leader().MountNamespaceVFS2()
+	if !mns.TryIncRef() {
+		return fmt.Errorf("container %q has stopped", *cid)
+	}
+	ctx := cm.l.k.SupervisorContext()
+	defer mns.DecRef(ctx)
+
+	var buf bytes.Buffer
+	cm.l.k.VFS().GenerateMountStats(ctx, mns.Root(), &buf)
+	*out = buf.String()
+	return nil
+}
+
+// SyscallStats returns the syscall latency histograms of the sandbox, followed
+// by up to *n of the slowest syscalls of the last minute.
+func (cm *containerManager) SyscallStats(n *int, out *string) error {
+	log.Debugf("containerManager.SyscallStats, n: %d", *n)
+	if !cm.l.root.conf.SyscallStats {
+		return fmt.Errorf("syscall statistics are not enabled; use --syscall-stats")
+	}
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "Latency histograms (upper bound: count):\n")
+	for _, lat := range cm.l.k.SyscallLatencies() {
+		fmt.Fprintf(&buf, "%-20s", lat.Name)
+		for i, c := range lat.Counts {
+			if i < len(lat.Bounds) {
+				fmt.Fprintf(&buf, " <%v:%d", lat.Bounds[i], c)
+			} else {
+				fmt.Fprintf(&buf, " inf:%d", c)
+			}
+		}
+		buf.WriteString("\n")
+	}
+	fmt.Fprintf(&buf, "\nSlowest syscalls of the last minute:\n")
+	for _, sc := range cm.l.k.SlowSyscalls(*n) {
+		fmt.Fprintf(&buf, "%s tid %d %s(%d)(%#x, %#x, %#x, %#x, %#x, %#x) = %#x", sc.Start.Format(gtime.RFC3339Nano), sc.TID, sc.Name, sc.Sysno, sc.Args[0], sc.Args[1], sc.Args[2], sc.Args[3], sc.Args[4], sc.Args[5], sc.Return)
+		if sc.Error != "" {
+			fmt.Fprintf(&buf, " (%s)", sc.Error)
+		}
+		fmt.Fprintf(&buf, " took %v\n", sc.Duration)
+	}
+	*out = buf.String()
+	return nil
+}
+
+// MemoryBreakdown returns the attribution of the sandbox's memory usage.
+func (cm *containerManager) MemoryBreakdown(_ *struct{}, out *MemoryBreakdown) error {
+	log.Debugf("containerManager.MemoryBreakdown")
+	mb, err := memoryBreakdown(cm.l.k)
+	if err != nil {
+		return err
+	}
+	*out = *mb
+	return nil
+}
+
+// StallEvents returns the stalls detected by the watchdog after the one
+// numbered *after.
+func (cm *containerManager) StallEvents(after *uint64, out *[]watchdog.StallEvent) error {
+	log.Debugf("containerManager.StallEvents, after: %d", *after)
+	*out = cm.l.watchdog.StallEvents(*after)
+	return nil
+}
+
 // CreateArgs contains arguments to the Create method.
 type CreateArgs struct {
 	// CID is the ID of the container to start.
@@ -334,9 +522,82 @@ func (cm *containerManager) Checkpoint(o *control.SaveOpts, _ *struct{}) error {
 		Kernel:   cm.l.k,
 		Watchdog: cm.l.watchdog,
 	}
+	if cm.l.root.conf.TCPCheckpoint {
+		// Saved connections are resumed by the restored sandbox.
+		k := cm.l.k
+		state.BeforeExit = func() { disableNICs(k) }
+	}
+	if cm.l.overlayUpper != nil {
+		// Keep the kernel paused from the flush until the save, so that the
+		// persisted upper layer matches the checkpoint.
+		cm.l.k.Pause()
+		defer cm.l.k.Unpause()
+		if err := cm.l.overlayUpper.Flush(cm.l.k.SupervisorContext()); err != nil {
+			return fmt.Errorf("flushing overlay upper layer: %w", err)
+		}
+	}
 	return state.Save(o, nil)
 }
 
+// MigrateOpts contains options for the Migrate RPC call.
+type MigrateOpts struct {
+	// MaxPause is the longest that the sandbox should be paused while the
+	// final part of its state is transferred.
+	MaxPause gtime.Duration
+
+	// MaxRounds is the maximum number of times memory is copied while the
+	// sandbox runs, or 0 for the default.
+	MaxRounds int
+
+	// FilePayload contains the destination for the migration stream.
+	urpc.FilePayload
+}
+
+// Migrate transfers the sandbox's state to a sandbox on another host, which is
+// being restored with RestoreOpts.Migration set, and then stops the sandbox.
+// Unlike Checkpoint, the sandbox continues to run while most of its memory is
+// transferred, and keeps running if migration fails.
+func (cm *containerManager) Migrate(o *MigrateOpts, _ *struct{}) error {
+	log.Debugf("containerManager.Migrate")
+	if len(o.FilePayload.Files) != 1 {
+		return control.ErrInvalidFiles
+	}
+	defer o.FilePayload.Files[0].Close()
+	if cm.l.overlayUpper != nil {
+		return fmt.Errorf("the persistent overlay upper layer can't be migrated")
+	}
+
+	k := cm.l.k
+	migrateOpts := state.MigrateOpts{
+		Destination: o.FilePayload.Files[0],
+		MaxPause:    o.MaxPause,
+		MaxRounds:   o.MaxRounds,
+		Callback: func(err error) {
+			if err != nil {
+				log.Warningf("Migration failed, resuming: %v", err)
+				return
+			}
+			log.Infof("Migration succeeded: exiting...")
+			// Connections now belong to the destination.
+			disableNICs(k)
+			k.SetSaveSuccess(false /* autosave */)
+			k.Kill(kernel.ExitStatus{})
+		},
+	}
+	return migrateOpts.Migrate(k.SupervisorContext(), k, cm.l.watchdog)
+}
+
+// disableNICs stops k's root network stack from sending packets. It is called
+// once the stack's connections have been saved to be resumed elsewhere, so
+// that closing their sockets when tasks are killed doesn't reset them.
+func disableNICs(k *kernel.Kernel) {
+	if eps, ok := k.RootNetworkNamespace().Stack().(*netstack.Stack); ok {
+		for id := range eps.Stack.NICInfo() {
+			eps.Stack.DisableNIC(id)
+		}
+	}
+}
+
 // Pause suspends a container.
 func (cm *containerManager) Pause(_, _ *struct{}) error {
 	log.Debugf("containerManager.Pause")
@@ -352,6 +613,13 @@ type RestoreOpts struct {
 
 	// SandboxID contains the ID of the sandbox.
 	SandboxID string
+
+	// Migration is true if the state file is a stream written by
+	// containerManager.Migrate, rather than a checkpoint.
+	Migration bool
+
+	// Key is the key with which the state file was encrypted, if any.
+	Key []byte
 }
 
 // Restore loads a container from a statefile.
@@ -383,6 +651,13 @@ func (cm *containerManager) Restore(o *RestoreOpts, _ *struct{}) error {
 	// Pause the kernel while we build a new one.
 	cm.l.k.Pause()
 
+	// The upper layer of the restored root overlay is part of the checkpoint,
+	// and the store's upper layer belongs to the kernel being replaced.
+	if cm.l.overlayUpper != nil {
+		cm.l.overlayUpper.Release(cm.l.k.SupervisorContext())
+		cm.l.overlayUpper = nil
+	}
+
 	p, err := createPlatform(cm.l.root.conf, deviceFile)
 	if err != nil {
 		return fmt.Errorf("creating platform: %v", err)
@@ -390,12 +665,23 @@ func (cm *containerManager) Restore(o *RestoreOpts, _ *struct{}) error {
 	k := &kernel.Kernel{
 		Platform: p,
 	}
-	mf, err := createMemoryFile()
+	// Core dump options aren't saved, since the pipe is a host file.
+	k.SetCoreDumpOptions(cm.l.k.CoreDumpOptions())
+	mf, err := createMemoryFile(cm.l.root.conf)
 	if err != nil {
 		return fmt.Errorf("creating memory file: %v", err)
 	}
 	k.SetMemoryFile(mf)
+	if cm.l.tmpfsSpillFD != 0 {
+		spillMF, err := createSpillMemoryFile(cm.l.tmpfsSpillFD)
+		if err != nil {
+			return fmt.Errorf("creating spill memory file: %v", err)
+		}
+		k.SetSpillMemoryFile(spillMF)
+	}
 	networkStack := cm.l.k.RootNetworkNamespace().Stack()
+	// The kernel being replaced was configured for this host's resources.
+	hostCPUs := cm.l.k.OnlineCores()
 	cm.l.k = k
 
 	// Set up the restore environment.
@@ -418,11 +704,12 @@ func (cm *containerManager) Restore(o *RestoreOpts, _ *struct{}) error {
 	if eps, ok := networkStack.(*netstack.Stack); ok {
 		stack.StackFromEnv = eps.Stack // FIXME(b/36201077)
 	}
+	// Streams, including migration streams, can't be checked in advance.
 	info, err := specFile.Stat()
 	if err != nil {
 		return err
 	}
-	if info.Size() == 0 {
+	if info.Mode().IsRegular() && info.Size() == 0 {
 		return fmt.Errorf("file cannot be empty")
 	}
 
@@ -438,15 +725,31 @@ func (cm *containerManager) Restore(o *RestoreOpts, _ *struct{}) error {
 	}
 
 	// Load the state.
-	loadOpts := state.LoadOpts{Source: specFile}
-	if err := loadOpts.Load(ctx, k, networkStack, time.NewCalibratedClocks(), &vfs.CompleteRestoreOptions{}); err != nil {
+	loadOpts := state.LoadOpts{Source: specFile, Key: o.Key}
+	load := loadOpts.Load
+	if o.Migration {
+		load = loadOpts.LoadMigration
+	}
+	if err := load(ctx, k, networkStack, time.NewCalibratedClocks(), &vfs.CompleteRestoreOptions{}); err != nil {
 		return err
 	}
 
+	// The sandbox may have been saved on a host with different resources.
+	if err := k.AdjustRestoredCores(hostCPUs); err != nil {
+		return err
+	}
+	if max := atomic.LoadUint64(&usage.MaximumTotalMemoryBytes); max > 0 {
+		used, err := k.MemoryFile().TotalUsage()
+		if err != nil {
+			return fmt.Errorf("getting restored memory usage: %v", err)
+		}
+		if used > max {
+			return fmt.Errorf("restored sandbox uses %d bytes of memory, more than the %d bytes available to it", used, max)
+		}
+	}
+
 	// Since we have a new kernel we also must make a new watchdog.
-	dogOpts := watchdog.DefaultOpts
-	dogOpts.TaskTimeoutAction = cm.l.root.conf.WatchdogAction
-	dog := watchdog.New(k, dogOpts)
+	dog := watchdog.New(k, watchdogOpts(cm.l.root.conf, k, cm.l.watchdogCheckpointFile))
 
 	// Change the loader fields to reflect the changes made when restoring.
 	cm.l.k = k
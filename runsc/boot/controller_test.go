@@ -0,0 +1,79 @@
+// Copyright 2021 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+
+package boot
+
+import (
+	"runtime"
+	"sync/atomic"
+	"testing"
+
+	"gvisor.dev/gvisor/pkg/sentry/fsimpl/testutil"
+	"gvisor.dev/gvisor/pkg/sentry/usage"
+)
+
+func TestResize(t *testing.T) {
+	k, err := testutil.Boot()
+	if err != nil {
+		t.Fatalf("Failed to create test kernel: %v", err)
+	}
+	defer runtime.GOMAXPROCS(runtime.GOMAXPROCS(-1))
+	totalMem := atomic.LoadUint64(&usage.MaximumTotalMemoryBytes)
+	defer atomic.StoreUint64(&usage.MaximumTotalMemoryBytes, totalMem)
+
+	cm := &containerManager{l: &Loader{k: k}}
+	maxCPUs := k.ApplicationCores()
+
+	// Take all CPUs but one offline.
+	if err := cm.Resize(&ResizeArgs{CPUs: 1}, nil); err != nil {
+		t.Fatalf("Resize to 1 CPU failed: %v", err)
+	}
+	if got := k.OnlineCores(); got != 1 {
+		t.Errorf("OnlineCores() got %d, want 1", got)
+	}
+	if got := runtime.GOMAXPROCS(-1); got != 1 {
+		t.Errorf("GOMAXPROCS got %d, want 1", got)
+	}
+
+	// The sandbox can't grow beyond its possible CPUs.
+	if err := cm.Resize(&ResizeArgs{CPUs: maxCPUs + 1}, nil); err == nil {
+		t.Errorf("Resize to %d CPUs succeeded, want error", maxCPUs+1)
+	}
+	if got := k.OnlineCores(); got != 1 {
+		t.Errorf("after failed Resize, OnlineCores() got %d, want 1", got)
+	}
+
+	// Bring the CPUs back online and change the total memory.
+	if err := cm.Resize(&ResizeArgs{CPUs: maxCPUs, TotalMem: 1 << 30}, nil); err != nil {
+		t.Fatalf("Resize to %d CPUs failed: %v", maxCPUs, err)
+	}
+	if got := k.OnlineCores(); got != maxCPUs {
+		t.Errorf("OnlineCores() got %d, want %d", got, maxCPUs)
+	}
+	if got := atomic.LoadUint64(&usage.MaximumTotalMemoryBytes); got != 1<<30 {
+		t.Errorf("total memory got %d, want %d", got, 1<<30)
+	}
+
+	// Zero leaves both unchanged.
+	if err := cm.Resize(&ResizeArgs{}, nil); err != nil {
+		t.Fatalf("Resize with no changes failed: %v", err)
+	}
+	if got := k.OnlineCores(); got != maxCPUs {
+		t.Errorf("OnlineCores() got %d, want %d", got, maxCPUs)
+	}
+	if got := atomic.LoadUint64(&usage.MaximumTotalMemoryBytes); got != 1<<30 {
+		t.Errorf("total memory got %d, want %d", got, 1<<30)
+	}
+}
@@ -15,6 +15,9 @@
 package boot
 
 import (
+	"gvisor.dev/gvisor/pkg/abi/linux"
+	"gvisor.dev/gvisor/pkg/log"
+	"gvisor.dev/gvisor/pkg/sentry/inet"
 	"gvisor.dev/gvisor/pkg/sentry/kernel"
 	"gvisor.dev/gvisor/pkg/sentry/usage"
 )
@@ -30,8 +33,52 @@ type Event struct {
 // Stats is the runc specific stats structure for stability when encoding and
 // decoding stats.
 type Stats struct {
+	CPU    CPU    `json:"cpu"`
 	Memory Memory `json:"memory"`
 	Pids   Pids   `json:"pids"`
+	Blkio  Blkio  `json:"blkio"`
+
+	// NetworkInterfaces are the interfaces of the sandbox's network
+	// namespace, which is shared by all containers.
+	NetworkInterfaces []*NetworkInterface `json:"network_interfaces,omitempty"`
+}
+
+// NetworkInterface contains stats on a network interface.
+type NetworkInterface struct {
+	Name      string `json:"name"`
+	RxBytes   uint64 `json:"rx_bytes"`
+	RxPackets uint64 `json:"rx_packets"`
+	RxErrors  uint64 `json:"rx_errors"`
+	RxDropped uint64 `json:"rx_dropped"`
+	TxBytes   uint64 `json:"tx_bytes"`
+	TxPackets uint64 `json:"tx_packets"`
+	TxErrors  uint64 `json:"tx_errors"`
+	TxDropped uint64 `json:"tx_dropped"`
+}
+
+// CPU contains stats on the CPU.
+type CPU struct {
+	Usage CPUUsage `json:"usage,omitempty"`
+}
+
+// CPUUsage contains stats on CPU time, in nanoseconds.
+type CPUUsage struct {
+	Total  uint64 `json:"total,omitempty"`
+	Kernel uint64 `json:"kernel"`
+	User   uint64 `json:"user"`
+}
+
+// BlkioEntry contains stats on a kind of I/O operation.
+type BlkioEntry struct {
+	Op    string `json:"op,omitempty"`
+	Value uint64 `json:"value,omitempty"`
+}
+
+// Blkio contains stats on I/O. Since the sandbox has no block devices, these
+// count the data read and written by read and write syscalls.
+type Blkio struct {
+	IoServiceBytesRecursive []BlkioEntry `json:"ioServiceBytesRecursive,omitempty"`
+	IoServicedRecursive     []BlkioEntry `json:"ioServicedRecursive,omitempty"`
 }
 
 // Pids contains stats on processes.
@@ -58,24 +105,114 @@ type Memory struct {
 	Raw       map[string]uint64 `json:"raw,omitempty"`
 }
 
-// Event gets the events from the container.
-func (cm *containerManager) Event(_ *struct{}, out *Event) error {
+// Event gets the events from the container with ID *cid.
+func (cm *containerManager) Event(cid *string, out *Event) error {
+	u := cm.l.k.TaskSet().ContainerUsage(*cid)
 	stats := &Stats{}
-	stats.populateMemory(cm.l.k)
-	stats.populatePIDs(cm.l.k)
-	*out = Event{Type: "stats", Data: stats}
+	stats.populateCPU(&u)
+	stats.populateMemory(cm.l.k, *cid, &u)
+	stats.populatePIDs(&u)
+	stats.populateBlkio(&u)
+	stats.populateNetwork(cm.l.k.RootNetworkNamespace().Stack())
+	*out = Event{Type: "stats", ID: *cid, Data: stats}
 	return nil
 }
 
-func (s *Stats) populateMemory(k *kernel.Kernel) {
+func (s *Stats) populateCPU(u *kernel.ContainerUsage) {
+	s.CPU.Usage = CPUUsage{
+		Total:  uint64(u.CPU.UserTime + u.CPU.SysTime),
+		Kernel: uint64(u.CPU.SysTime),
+		User:   uint64(u.CPU.UserTime),
+	}
+}
+
+// populateMemory reports the resident set size of the container's processes.
+// If the container is the only one in the sandbox, the memory usage of the
+// sandbox is reported instead, which also includes memory that can't be
+// attributed to a process, such as the page cache.
+//
+// Raw holds the values of Linux's memory.stat that apply, which the shim
+// uses to compute the working set. None of the memory is reported as
+// inactive, since the sentry doesn't reclaim it under memory pressure.
+func (s *Stats) populateMemory(k *kernel.Kernel, cid string, u *kernel.ContainerUsage) {
+	only := true
+	for _, tg := range k.TaskSet().Root.ThreadGroups() {
+		if tg.Leader() != nil && tg.Leader().ContainerID() != cid {
+			only = false
+			break
+		}
+	}
+	if !only {
+		s.Memory.Usage = MemoryEntry{
+			Usage: u.RSS,
+		}
+		s.Memory.Raw = map[string]uint64{
+			"rss":       u.RSS,
+			"total_rss": u.RSS,
+		}
+		return
+	}
 	mem := k.MemoryFile()
 	mem.UpdateUsage()
-	_, totalUsage := usage.MemoryAccounting.Copy()
+	m, totalUsage := usage.MemoryAccounting.Copy()
+	cache := m.PageCache + m.Tmpfs + m.Ramdiskfs
+	s.Memory.Cache = cache
 	s.Memory.Usage = MemoryEntry{
 		Usage: totalUsage,
 	}
+	s.Memory.Raw = map[string]uint64{
+		"cache":             cache,
+		"rss":               m.Anonymous,
+		"mapped_file":       m.Mapped,
+		"total_cache":       cache,
+		"total_rss":         m.Anonymous,
+		"total_mapped_file": m.Mapped,
+	}
+}
+
+func (s *Stats) populatePIDs(u *kernel.ContainerUsage) {
+	s.Pids.Current = u.Processes
+}
+
+func (s *Stats) populateBlkio(u *kernel.ContainerUsage) {
+	s.Blkio = Blkio{
+		IoServiceBytesRecursive: []BlkioEntry{
+			{Op: "Read", Value: u.IO.CharsRead},
+			{Op: "Write", Value: u.IO.CharsWritten},
+		},
+		IoServicedRecursive: []BlkioEntry{
+			{Op: "Read", Value: u.IO.ReadSyscalls},
+			{Op: "Write", Value: u.IO.WriteSyscalls},
+		},
+	}
 }
 
-func (s *Stats) populatePIDs(k *kernel.Kernel) {
-	s.Pids.Current = uint64(len(k.TaskSet().Root.ThreadGroups()))
+// populateNetwork reports the stats of the network interfaces of stack, the
+// stack of the root network namespace, other than loopback.
+func (s *Stats) populateNetwork(stack inet.Stack) {
+	if stack == nil {
+		return
+	}
+	for _, i := range stack.Interfaces() {
+		if i.Flags&linux.IFF_LOOPBACK != 0 {
+			continue
+		}
+		var stats inet.StatDev
+		if err := stack.Statistics(&stats, i.Name); err != nil {
+			log.Warningf("Failed to retrieve interface statistics for %v: %v", i.Name, err)
+			continue
+		}
+		// See /proc/net/dev for the order of stats.
+		s.NetworkInterfaces = append(s.NetworkInterfaces, &NetworkInterface{
+			Name:      i.Name,
+			RxBytes:   stats[0],
+			RxPackets: stats[1],
+			RxErrors:  stats[2],
+			RxDropped: stats[3],
+			TxBytes:   stats[8],
+			TxPackets: stats[9],
+			TxErrors:  stats[10],
+			TxDropped: stats[11],
+		})
+	}
 }
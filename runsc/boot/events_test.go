@@ -0,0 +1,148 @@
+// Copyright 2021 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package boot
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+
+	"gvisor.dev/gvisor/pkg/abi/linux"
+	"gvisor.dev/gvisor/pkg/sentry/fsimpl/testutil"
+	"gvisor.dev/gvisor/pkg/sentry/fsimpl/tmpfs"
+	"gvisor.dev/gvisor/pkg/sentry/inet"
+	"gvisor.dev/gvisor/pkg/sentry/kernel"
+	"gvisor.dev/gvisor/pkg/sentry/kernel/auth"
+	"gvisor.dev/gvisor/pkg/sentry/vfs"
+)
+
+func TestPopulateMemory(t *testing.T) {
+	k, err := testutil.Boot()
+	if err != nil {
+		t.Fatalf("Failed to create test kernel: %v", err)
+	}
+	ctx := k.SupervisorContext()
+	creds := auth.CredentialsFromContext(ctx)
+	mns, err := k.VFS().NewMountNamespace(ctx, creds, "", tmpfs.Name, &vfs.MountOptions{})
+	if err != nil {
+		t.Fatalf("Failed to create new mount namespace: %v", err)
+	}
+	s := testutil.NewSystem(ctx, t, k.VFS(), mns)
+	defer s.Destroy()
+	// The task belongs to the container with the empty ID.
+	tg := k.NewThreadGroup(nil, k.RootPIDNamespace(), kernel.NewSignalHandlers(), linux.SIGCHLD, k.GlobalInit().Limits())
+	if _, err := testutil.CreateTask(ctx, "task", tg, mns, s.Root, s.Root); err != nil {
+		t.Fatalf("CreateTask failed: %v", err)
+	}
+
+	// The only container in the sandbox reports the sandbox's memory.
+	u := k.TaskSet().ContainerUsage("")
+	var only Stats
+	only.populateMemory(k, "", &u)
+	raw := only.Memory.Raw
+	for _, key := range []string{"cache", "rss", "mapped_file", "total_cache", "total_rss", "total_mapped_file"} {
+		if _, ok := raw[key]; !ok {
+			t.Errorf("Raw got %v, missing %q", raw, key)
+		}
+	}
+	if raw["cache"] != only.Memory.Cache || raw["total_cache"] != only.Memory.Cache {
+		t.Errorf("Raw got cache %d and total_cache %d, want Cache %d", raw["cache"], raw["total_cache"], only.Memory.Cache)
+	}
+	if raw["total_rss"] != raw["rss"] {
+		t.Errorf("Raw got total_rss %d, want rss %d", raw["total_rss"], raw["rss"])
+	}
+	if used := raw["rss"] + raw["cache"]; used > only.Memory.Usage.Usage {
+		t.Errorf("got rss and cache of %d, more than usage %d", used, only.Memory.Usage.Usage)
+	}
+	// The working set is computed by subtracting inactive memory.
+	for _, key := range []string{"inactive_file", "total_inactive_file"} {
+		if _, ok := raw[key]; ok {
+			t.Errorf("Raw got %v, want no %q", raw, key)
+		}
+	}
+
+	// Other containers share the sandbox, so they report their RSS.
+	u = k.TaskSet().ContainerUsage("other")
+	var shared Stats
+	shared.populateMemory(k, "other", &u)
+	want := Memory{
+		Usage: MemoryEntry{Usage: u.RSS},
+		Raw: map[string]uint64{
+			"rss":       u.RSS,
+			"total_rss": u.RSS,
+		},
+	}
+	if !reflect.DeepEqual(shared.Memory, want) {
+		t.Errorf("Memory got %+v, want %+v", shared.Memory, want)
+	}
+}
+
+// statsStack is an inet.Stack with interface statistics.
+type statsStack struct {
+	*inet.TestStack
+	stats map[string]inet.StatDev
+}
+
+// Statistics implements inet.Stack.Statistics.
+func (s *statsStack) Statistics(stat interface{}, arg string) error {
+	st, ok := s.stats[arg]
+	if !ok {
+		return fmt.Errorf("no interface %q", arg)
+	}
+	*stat.(*inet.StatDev) = st
+	return nil
+}
+
+func TestPopulateNetwork(t *testing.T) {
+	stack := &statsStack{
+		TestStack: inet.NewTestStack(),
+		stats: map[string]inet.StatDev{
+			"lo":   {1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16},
+			"eth0": {100, 101, 102, 103, 104, 105, 106, 107, 108, 109, 110, 111, 112, 113, 114, 115},
+		},
+	}
+	stack.InterfacesMap = map[int32]inet.Interface{
+		1: {Name: "lo", Flags: linux.IFF_LOOPBACK | linux.IFF_UP},
+		2: {Name: "eth0", Flags: linux.IFF_UP},
+		// Interfaces whose stats can't be read are skipped.
+		3: {Name: "eth1", Flags: linux.IFF_UP},
+	}
+
+	var s Stats
+	s.populateNetwork(stack)
+	want := []*NetworkInterface{
+		{
+			Name:      "eth0",
+			RxBytes:   100,
+			RxPackets: 101,
+			RxErrors:  102,
+			RxDropped: 103,
+			TxBytes:   108,
+			TxPackets: 109,
+			TxErrors:  110,
+			TxDropped: 111,
+		},
+	}
+	if !reflect.DeepEqual(s.NetworkInterfaces, want) {
+		t.Errorf("NetworkInterfaces got %+v, want %+v", s.NetworkInterfaces, want)
+	}
+
+	// Sandboxes without a network stack have no interfaces.
+	var none Stats
+	none.populateNetwork(nil)
+	if none.NetworkInterfaces != nil {
+		t.Errorf("NetworkInterfaces got %+v without a stack, want none", none.NetworkInterfaces)
+	}
+}
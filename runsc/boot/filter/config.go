@@ -168,6 +168,14 @@ var allowedSyscalls = seccomp.SyscallRules{
 			seccomp.EqualTo(0),
 		},
 	},
+	// Used by virtiofs filesystems to allocate the memory shared with their
+	// vhost-user backend.
+	unix.SYS_MEMFD_CREATE: []seccomp.Rule{
+		{
+			seccomp.MatchAny{}, /* name */
+			seccomp.EqualTo(unix.MFD_CLOEXEC),
+		},
+	},
 	syscall.SYS_MINCORE: {},
 	// Used by the Go runtime as a temporarily workaround for a Linux
 	// 5.2-5.4 bug.
@@ -607,6 +615,34 @@ func hostInetFilters() seccomp.SyscallRules {
 	}
 }
 
+// hostUDSCreateFilters contains syscalls that are needed to accept connections
+// on host sockets created by binding to paths on gofer mounts.
+func hostUDSCreateFilters() seccomp.SyscallRules {
+	return seccomp.SyscallRules{
+		syscall.SYS_ACCEPT4: []seccomp.Rule{
+			{
+				seccomp.MatchAny{},
+				seccomp.MatchAny{},
+				seccomp.MatchAny{},
+				seccomp.EqualTo(syscall.SOCK_NONBLOCK | syscall.SOCK_CLOEXEC),
+			},
+		},
+	}
+}
+
+func proxiedDeviceFilters(fd int, requests []uint32) seccomp.SyscallRules {
+	rules := make([]seccomp.Rule, 0, len(requests))
+	for _, request := range requests {
+		rules = append(rules, seccomp.Rule{
+			seccomp.EqualTo(fd),
+			seccomp.EqualTo(request),
+		})
+	}
+	return seccomp.SyscallRules{
+		syscall.SYS_IOCTL: rules,
+	}
+}
+
 func controlServerFilters(fd int) seccomp.SyscallRules {
 	return seccomp.SyscallRules{
 		syscall.SYS_ACCEPT: []seccomp.Rule{
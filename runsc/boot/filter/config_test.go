@@ -0,0 +1,103 @@
+// Copyright 2021 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package filter
+
+import (
+	"syscall"
+	"testing"
+
+	"gvisor.dev/gvisor/pkg/abi/linux"
+	"gvisor.dev/gvisor/pkg/bpf"
+	"gvisor.dev/gvisor/pkg/seccomp"
+	"gvisor.dev/gvisor/pkg/sentry/devices/attestdev"
+	"gvisor.dev/gvisor/pkg/sentry/devices/kfddev"
+	"gvisor.dev/gvisor/pkg/usermem"
+)
+
+// ioctlInput returns the seccomp input of ioctl(fd, request).
+func ioctlInput(fd int, request uint32) bpf.Input {
+	d := linux.SeccompData{
+		Nr:   syscall.SYS_IOCTL,
+		Arch: seccomp.LINUX_AUDIT_ARCH,
+		Args: [6]uint64{uint64(fd), uint64(request)},
+	}
+	buf := make([]byte, d.SizeBytes())
+	d.MarshalUnsafe(buf)
+	return bpf.InputBytes{
+		Data:  buf,
+		Order: usermem.ByteOrder,
+	}
+}
+
+// TestProxiedDeviceFilters checks that only the ioctls of each proxied
+// device are allowed, and only on the FD of that device.
+func TestProxiedDeviceFilters(t *testing.T) {
+	const (
+		kfdFD = 10
+		sevFD = 11
+	)
+	sevSpec, err := attestdev.Spec(attestdev.SEVGuest)
+	if err != nil {
+		t.Fatalf("attestdev.Spec failed: %v", err)
+	}
+	tdxSpec, err := attestdev.Spec(attestdev.TDXGuest)
+	if err != nil {
+		t.Fatalf("attestdev.Spec failed: %v", err)
+	}
+	kfdSpec := kfddev.Spec()
+
+	rules := seccomp.NewSyscallRules()
+	rules.Merge(proxiedDeviceFilters(kfdFD, kfdSpec.Requests()))
+	rules.Merge(proxiedDeviceFilters(sevFD, sevSpec.Requests()))
+	instrs, err := seccomp.BuildProgram([]seccomp.RuleSet{
+		{
+			Rules:  rules,
+			Action: linux.SECCOMP_RET_ALLOW,
+		},
+	}, linux.SECCOMP_RET_TRAP, linux.SECCOMP_RET_KILL_THREAD)
+	if err != nil {
+		t.Fatalf("BuildProgram failed: %v", err)
+	}
+	p, err := bpf.Compile(instrs)
+	if err != nil {
+		t.Fatalf("bpf.Compile failed: %v", err)
+	}
+
+	check := func(fd int, request uint32, want linux.BPFAction) {
+		t.Helper()
+		got, err := bpf.Exec(p, ioctlInput(fd, request))
+		if err != nil {
+			t.Fatalf("bpf.Exec failed: %v", err)
+		}
+		if got != uint32(want) {
+			t.Errorf("ioctl(%d, %#x) got action %#x, want %#x", fd, request, got, want)
+		}
+	}
+	for _, request := range kfdSpec.Requests() {
+		check(kfdFD, request, linux.SECCOMP_RET_ALLOW)
+		check(sevFD, request, linux.SECCOMP_RET_TRAP)
+	}
+	for _, request := range sevSpec.Requests() {
+		check(sevFD, request, linux.SECCOMP_RET_ALLOW)
+		check(kfdFD, request, linux.SECCOMP_RET_TRAP)
+	}
+	// Devices that aren't proxied have no rules.
+	for _, request := range tdxSpec.Requests() {
+		check(kfdFD, request, linux.SECCOMP_RET_TRAP)
+		check(sevFD, request, linux.SECCOMP_RET_TRAP)
+	}
+	// Other ioctls are denied on the device FDs.
+	check(kfdFD, linux.TCGETS, linux.SECCOMP_RET_TRAP)
+	check(sevFD, linux.TCGETS, linux.SECCOMP_RET_TRAP)
+}
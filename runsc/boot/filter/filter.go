@@ -28,7 +28,11 @@ type Options struct {
 	Platform      platform.Platform
 	HostNetwork   bool
 	ProfileEnable bool
+	HostUDSCreate bool
 	ControllerFD  int
+	// ProxiedIoctls maps the FDs of host devices that are proxied to the
+	// sandbox to the ioctl requests that may be issued on them.
+	ProxiedIoctls map[int][]uint32
 }
 
 // Install installs seccomp filters for based on the given platform.
@@ -44,10 +48,17 @@ func Install(opt Options) error {
 		Report("host networking enabled: syscall filters less restrictive!")
 		s.Merge(hostInetFilters())
 	}
+	if opt.HostUDSCreate {
+		Report("host UDS creation enabled: syscall filters less restrictive!")
+		s.Merge(hostUDSCreateFilters())
+	}
 	if opt.ProfileEnable {
 		Report("profile enabled: syscall filters less restrictive!")
 		s.Merge(profileFilters())
 	}
+	for fd, requests := range opt.ProxiedIoctls {
+		s.Merge(proxiedDeviceFilters(fd, requests))
+	}
 
 	s.Merge(opt.Platform.SyscallFilters())
 
@@ -34,6 +34,7 @@ import (
 	"gvisor.dev/gvisor/pkg/sentry/fsimpl/devpts"
 	"gvisor.dev/gvisor/pkg/sentry/fsimpl/devtmpfs"
 	gofervfs2 "gvisor.dev/gvisor/pkg/sentry/fsimpl/gofer"
+	"gvisor.dev/gvisor/pkg/sentry/fsimpl/overlay"
 	procvfs2 "gvisor.dev/gvisor/pkg/sentry/fsimpl/proc"
 	sysvfs2 "gvisor.dev/gvisor/pkg/sentry/fsimpl/sys"
 	tmpfsvfs2 "gvisor.dev/gvisor/pkg/sentry/fsimpl/tmpfs"
@@ -60,14 +61,24 @@ const (
 	// MountPrefix is the annotation prefix for mount hints.
 	MountPrefix = "dev.gvisor.spec.mount."
 
+	// ShmSizeAnnotation is the annotation that sets the size of a tmpfs that
+	// is mounted at /dev/shm in all containers in the pod. The value has the
+	// same format as tmpfs' size mount option.
+	ShmSizeAnnotation = "dev.gvisor.spec.shm.size"
+
 	// Supported filesystems that map to different internal filesystem.
-	bind   = "bind"
-	nonefs = "none"
+	bind     = "bind"
+	nonefs   = "none"
+	cgroupV1 = "cgroup"
 )
 
 // tmpfs has some extra supported options that we must pass through.
 var tmpfsAllowedData = []string{"mode", "uid", "gid"}
 
+// tmpfsVFS2AllowedData are the options passed through to VFS2 tmpfs, which
+// also supports size limits and spilling data to host storage.
+var tmpfsVFS2AllowedData = append([]string{"size", "nr_inodes", "spill_threshold"}, tmpfsAllowedData...)
+
 func addOverlay(ctx context.Context, conf *config.Config, lower *fs.Inode, name string, lowerFlags fs.MountSourceFlags) (*fs.Inode, error) {
 	// Upper layer uses the same flags as lower, but it must be read-write.
 	upperFlags := lowerFlags
@@ -164,8 +175,16 @@ func compileMounts(spec *specs.Spec) []specs.Mount {
 	return mounts
 }
 
-// p9MountData creates a slice of p9 mount data.
-func p9MountData(fd int, fa config.FileAccessType, vfs2 bool) []string {
+// idMapOptions are the options of bind mounts that make them ID-mapped
+// mounts, which present files with different owners than on the host. Each is
+// a space-separated list of "id:hostid:length" entries, mapping a range of IDs
+// in the sandbox to an equally-sized range of IDs reported by the gofer. They
+// are only supported by VFS2.
+var idMapOptions = []string{"uidmap", "gidmap"}
+
+// p9MountData creates a slice of p9 mount data. Options only supported by VFS2
+// are derived from conf if vfs2 is set.
+func p9MountData(fd int, fa config.FileAccessType, vfs2 bool, conf *config.Config) []string {
 	opts := []string{
 		"trans=fd",
 		"rfdno=" + strconv.Itoa(fd),
@@ -177,7 +196,32 @@ func p9MountData(fd int, fa config.FileAccessType, vfs2 bool) []string {
 		opts = append(opts, "privateunixsocket=true")
 	}
 	if fa == config.FileAccessShared {
-		opts = append(opts, "cache=remote_revalidating")
+		if vfs2 && conf.FSGoferInvalidations {
+			opts = append(opts, "cache=remote_invalidating")
+		} else {
+			opts = append(opts, "cache=remote_revalidating")
+		}
+	}
+	if !vfs2 {
+		return opts
+	}
+	if conf.GoferChannels > 0 {
+		opts = append(opts, "channels="+strconv.Itoa(conf.GoferChannels))
+	}
+	if conf.GoferRemoteLocks {
+		opts = append(opts, "locks=remote")
+	}
+	if conf.FSGoferHostUDSCreate {
+		opts = append(opts, "host_uds_create")
+	}
+	if conf.GoferReadahead != 0 {
+		opts = append(opts, "readahead="+strconv.FormatUint(uint64(conf.GoferReadahead), 10))
+	}
+	if fa == config.FileAccessExclusive && conf.GoferDirtyBytes != 0 {
+		opts = append(opts, "dirty_bytes="+strconv.FormatUint(uint64(conf.GoferDirtyBytes), 10))
+		if conf.GoferDirtyBackgroundBytes != 0 {
+			opts = append(opts, "dirty_background_bytes="+strconv.FormatUint(uint64(conf.GoferDirtyBackgroundBytes), 10))
+		}
 	}
 	return opts
 }
@@ -497,6 +541,12 @@ func filterUnsupportedOptions(mount specs.Mount) []string {
 // podMountHints contains a collection of mountHints for the pod.
 type podMountHints struct {
 	mounts map[string]*mountHint
+
+	// shm, if not nil, is the tmpfs mounted at /dev/shm in all containers in
+	// the pod, in place of the /dev/shm directory shared by all containers. It
+	// is set by ShmSizeAnnotation, and takes precedence over containers'
+	// /dev/shm mounts, which are always ignored.
+	shm *mountHint
 }
 
 func newPodMountHints(spec *specs.Spec) (*podMountHints, error) {
@@ -545,7 +595,24 @@ func newPodMountHints(spec *specs.Spec) (*podMountHints, error) {
 		}
 	}
 
-	return &podMountHints{mounts: mnts}, nil
+	p := &podMountHints{mounts: mnts}
+	if size, ok := spec.Annotations[ShmSizeAnnotation]; ok {
+		if len(size) == 0 || strings.Contains(size, ",") {
+			return nil, fmt.Errorf("invalid /dev/shm size: %s=%q", ShmSizeAnnotation, size)
+		}
+		log.Infof("Shared /dev/shm found, size: %s", size)
+		p.shm = &mountHint{
+			name:  "shm",
+			share: pod,
+			mount: specs.Mount{
+				Destination: "/dev/shm",
+				Type:        tmpfsvfs2.Name,
+				Source:      "shm",
+				Options:     []string{"size=" + size},
+			},
+		}
+	}
+	return p, nil
 }
 
 func (p *podMountHints) findMount(mount specs.Mount) *mountHint {
@@ -570,15 +637,35 @@ type containerMounter struct {
 	k *kernel.Kernel
 
 	hints *podMountHints
+
+	// rootfsType is the filesystem type of the root filesystem's image if
+	// it is read from an image rather than served by the gofer, or empty
+	// otherwise. The image is passed in place of the root gofer connection.
+	rootfsType string
+
+	// rootIDMap are the idMapOptions of the root filesystem if it's served
+	// by the gofer.
+	rootIDMap []string
+
+	// overlayUpper, if not nil, persists the upper layer of the root
+	// overlay. It is only set for the root container.
+	overlayUpper *overlay.UpperStore
+
+	// goferReadCache, if not nil, caches the contents of files read from
+	// gofer mounts that the sandbox doesn't write to.
+	goferReadCache *gofervfs2.ReadCache
 }
 
 func newContainerMounter(spec *specs.Spec, goferFDs []*fd.FD, k *kernel.Kernel, hints *podMountHints) *containerMounter {
+	rootfsType, _, _ := specutils.RootfsImage(spec)
 	return &containerMounter{
-		root:   spec.Root,
-		mounts: compileMounts(spec),
-		fds:    fdDispenser{fds: goferFDs},
-		k:      k,
-		hints:  hints,
+		root:       spec.Root,
+		mounts:     compileMounts(spec),
+		fds:        fdDispenser{fds: goferFDs},
+		k:          k,
+		hints:      hints,
+		rootfsType: rootfsType,
+		rootIDMap:  specutils.RootfsIDMapOptions(spec),
 	}
 }
 
@@ -603,6 +690,9 @@ func (c *containerMounter) processHints(conf *config.Config, creds *auth.Credent
 		}
 		hint.root = inode
 	}
+	if c.hints.shm != nil {
+		log.Warningf("Ignoring %s, which requires VFS2", ShmSizeAnnotation)
+	}
 	return nil
 }
 
@@ -726,11 +816,14 @@ func (c *containerMounter) mountSharedMaster(ctx context.Context, conf *config.C
 func (c *containerMounter) createRootMount(ctx context.Context, conf *config.Config) (*fs.Inode, error) {
 	// First construct the filesystem from the spec.Root.
 	mf := fs.MountSourceFlags{ReadOnly: c.root.Readonly || conf.Overlay}
+	if len(c.rootIDMap) != 0 {
+		return nil, fmt.Errorf("ID-mapped root filesystem requires VFS2")
+	}
 
 	fd := c.fds.remove()
 	log.Infof("Mounting root over 9P, ioFD: %d", fd)
 	p9FS := mustFindFilesystem("9p")
-	opts := p9MountData(fd, conf.FileAccess, false /* vfs2 */)
+	opts := p9MountData(fd, conf.FileAccess, false /* vfs2 */, conf)
 
 	if conf.OverlayfsStaleRead {
 		// We can't check for overlayfs here because sandbox is chroot'ed and gofer
@@ -790,9 +883,17 @@ func (c *containerMounter) getMountNameAndOptions(conf *config.Config, m specs.M
 		}
 
 	case bind:
+		idMap, err := parseAndFilterOptions(m.Options, idMapOptions...)
+		if err != nil {
+			return "", nil, false, err
+		}
+		if len(idMap) != 0 && !conf.VFS2 {
+			return "", nil, false, fmt.Errorf("ID-mapped mount %q requires VFS2", m.Destination)
+		}
 		fd := c.fds.remove()
 		fsName = gofervfs2.Name
-		opts = p9MountData(fd, c.getMountAccessType(m), conf.VFS2)
+		opts = p9MountData(fd, c.getMountAccessType(m), conf.VFS2, conf)
+		opts = append(opts, idMap...)
 		// If configured, add overlay to all writable mounts.
 		useOverlay = conf.Overlay && !mountFlags(m.Options).ReadOnly
 
@@ -945,7 +1046,7 @@ func (c *containerMounter) createRestoreEnvironment(conf *config.Config) (*fs.Re
 
 	// Add root mount.
 	fd := c.fds.remove()
-	opts := p9MountData(fd, conf.FileAccess, false /* vfs2 */)
+	opts := p9MountData(fd, conf.FileAccess, false /* vfs2 */, conf)
 
 	mf := fs.MountSourceFlags{}
 	if c.root.Readonly || conf.Overlay {
@@ -77,6 +77,43 @@ func TestPodMountHintsHappy(t *testing.T) {
 	}
 }
 
+func TestPodMountHintsShm(t *testing.T) {
+	spec := &specs.Spec{
+		Annotations: map[string]string{
+			ShmSizeAnnotation: "64m",
+		},
+	}
+	podHints, err := newPodMountHints(spec)
+	if err != nil {
+		t.Fatalf("newPodMountHints failed: %v", err)
+	}
+	shm := podHints.shm
+	if shm == nil {
+		t.Fatalf("shm hint not set")
+	}
+	if want := "/dev/shm"; want != shm.mount.Destination {
+		t.Errorf("shm destination, want: %q, got: %q", want, shm.mount.Destination)
+	}
+	if want := "tmpfs"; want != shm.mount.Type {
+		t.Errorf("shm type, want: %q, got: %q", want, shm.mount.Type)
+	}
+	if want := pod; want != shm.share {
+		t.Errorf("shm share, want: %q, got: %q", want, shm.share)
+	}
+	if want := []string{"size=64m"}; !reflect.DeepEqual(want, shm.mount.Options) {
+		t.Errorf("shm options, want: %q, got: %q", want, shm.mount.Options)
+	}
+
+	// Without the annotation, /dev/shm isn't mounted.
+	podHints, err = newPodMountHints(&specs.Spec{})
+	if err != nil {
+		t.Fatalf("newPodMountHints failed: %v", err)
+	}
+	if podHints.shm != nil {
+		t.Errorf("shm hint set without annotation: %+v", podHints.shm)
+	}
+}
+
 func TestPodMountHintsErrors(t *testing.T) {
 	for _, tst := range []struct {
 		name        string
@@ -178,6 +215,13 @@ func TestPodMountHintsErrors(t *testing.T) {
 			},
 			error: "have the same mount source",
 		},
+		{
+			name: "empty shm size",
+			annotations: map[string]string{
+				ShmSizeAnnotation: "",
+			},
+			error: "invalid /dev/shm size",
+		},
 	} {
 		t.Run(tst.name, func(t *testing.T) {
 			spec := &specs.Spec{Annotations: tst.annotations}
@@ -0,0 +1,331 @@
+// Copyright 2020 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package boot
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"path"
+	"time"
+
+	specs "github.com/opencontainers/runtime-spec/specs-go"
+	"gvisor.dev/gvisor/pkg/abi/linux"
+	"gvisor.dev/gvisor/pkg/context"
+	"gvisor.dev/gvisor/pkg/log"
+	"gvisor.dev/gvisor/pkg/sentry/arch"
+	"gvisor.dev/gvisor/pkg/sentry/fs"
+	"gvisor.dev/gvisor/pkg/sentry/fsimpl/pipefs"
+	"gvisor.dev/gvisor/pkg/sentry/kernel"
+	"gvisor.dev/gvisor/pkg/sentry/kernel/auth"
+	"gvisor.dev/gvisor/pkg/sentry/kernel/pipe"
+	"gvisor.dev/gvisor/pkg/sentry/limits"
+	"gvisor.dev/gvisor/pkg/sentry/vfs"
+	"gvisor.dev/gvisor/pkg/sync"
+	"gvisor.dev/gvisor/pkg/syserror"
+	"gvisor.dev/gvisor/pkg/usermem"
+	"gvisor.dev/gvisor/pkg/waiter"
+)
+
+// This file implements the OCI startContainer hooks, which run in the
+// container rather than on the host. Their path is resolved in the container's
+// root file system, and they run in its mount and PID namespaces, after its
+// file system is set up and before its init process runs. createContainer
+// hooks are resolved on the host, so runsc runs them when it creates the
+// container.
+
+// hookOutputLimit is the amount of output of a hook that is kept for error
+// messages.
+const hookOutputLimit = 4 << 10
+
+// hasContainerHooks returns true if spec has hooks that run in the container.
+func hasContainerHooks(spec *specs.Spec) bool {
+	return spec.Hooks != nil && len(spec.Hooks.StartContainer) > 0
+}
+
+// runContainerHooks runs the startContainer hooks of the container with init
+// process tg, and then lets tg run. tg's leader must have been stopped with
+// BeginExternalStop before it was started.
+//
+// "If any startContainer hook fails, the runtime MUST generate an error, stop
+// the container" -OCI spec. tg is killed in that case.
+func (l *Loader) runContainerHooks(cid string, spec *specs.Spec, tg *kernel.ThreadGroup) error {
+	defer tg.Leader().EndExternalStop()
+
+	state := specs.State{
+		Version: specs.Version,
+		ID:      cid,
+		Status:  "created",
+		Pid:     int(tg.PIDNamespace().IDOfThreadGroup(tg)),
+		// The bundle is on the host, out of reach of the container.
+		Annotations: spec.Annotations,
+	}
+	for _, h := range spec.Hooks.StartContainer {
+		if err := l.runContainerHook(cid, h, state, tg); err != nil {
+			if err := tg.SendSignal(&arch.SignalInfo{Signo: int32(linux.SIGKILL)}); err != nil {
+				log.Warningf("Failed to kill container %q: %v", cid, err)
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+// runContainerHook runs h in the namespaces of tg, and waits for it to exit.
+// The hook reads state from stdin, and its stdout and stderr are reported if
+// it fails.
+func (l *Loader) runContainerHook(cid string, h specs.Hook, state specs.State, tg *kernel.ThreadGroup) error {
+	log.Debugf("Executing hook %+v in container %q, state: %+v", h, cid, state)
+	if !path.IsAbs(h.Path) {
+		return fmt.Errorf("path for hook is not absolute: %q", h.Path)
+	}
+	stateJSON, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+
+	argv := h.Args
+	if len(argv) == 0 {
+		argv = []string{h.Path}
+	}
+	procArgs := kernel.CreateProcessArgs{
+		Filename:                h.Path,
+		Argv:                    argv,
+		Envv:                    h.Env,
+		WorkingDirectory:        "/",
+		Credentials:             auth.NewRootCredentials(l.k.RootUserNamespace()),
+		Umask:                   0022,
+		Limits:                  limits.NewLimitSet(),
+		MaxSymlinkTraversals:    linux.MaxSymlinkTraversals,
+		UTSNamespace:            l.k.RootUTSNamespace(),
+		IPCNamespace:            l.k.RootIPCNamespace(),
+		AbstractSocketNamespace: l.k.RootAbstractSocketNamespace(),
+		ContainerID:             cid,
+		PIDNamespace:            tg.PIDNamespace(),
+	}
+
+	// CreateProcess takes the reference on the mount namespace if successful.
+	if kernel.VFS2Enabled {
+		procArgs.MountNamespaceVFS2 = tg.Leader().MountNamespaceVFS2()
+		if !procArgs.MountNamespaceVFS2.TryIncRef() {
+			return fmt.Errorf("container %q has stopped", cid)
+		}
+	} else {
+		var reffed bool
+		tg.Leader().WithMuLocked(func(t *kernel.Task) {
+			procArgs.MountNamespace = t.MountNamespace()
+			reffed = procArgs.MountNamespace.TryIncRef()
+		})
+		if !reffed {
+			return fmt.Errorf("container %q has stopped", cid)
+		}
+	}
+	ctx := procArgs.NewContext(l.k)
+
+	hookTG, output, err := l.startHook(ctx, &procArgs, stateJSON)
+	if err != nil {
+		if procArgs.MountNamespaceVFS2 != nil {
+			procArgs.MountNamespaceVFS2.DecRef(ctx)
+		}
+		if procArgs.MountNamespace != nil {
+			procArgs.MountNamespace.DecRef(ctx)
+		}
+		return fmt.Errorf("starting hook %q: %v", h.Path, err)
+	}
+
+	exited := make(chan struct{})
+	go func() {
+		hookTG.WaitExited()
+		close(exited)
+	}()
+	var timer <-chan time.Time
+	if h.Timeout != nil {
+		timer = time.After(time.Duration(*h.Timeout) * time.Second)
+	}
+	select {
+	case <-exited:
+	case <-timer:
+		_ = hookTG.SendSignal(&arch.SignalInfo{Signo: int32(linux.SIGKILL)})
+		<-exited
+		output.wait()
+		return fmt.Errorf("timeout executing hook %q\noutput: %s", h.Path, output)
+	}
+	output.wait()
+
+	if es := hookTG.ExitStatus(); es.Code != 0 || es.Signaled() {
+		return fmt.Errorf("failure executing hook %q, exit status: %#x\noutput: %s", h.Path, es.Status(), output)
+	}
+	log.Debugf("Execute hook %q in container %q success!", h.Path, cid)
+	return nil
+}
+
+// startHook starts a hook process, with stdin reading stateJSON and stdout
+// and stderr writing to the returned output.
+func (l *Loader) startHook(ctx context.Context, procArgs *kernel.CreateProcessArgs, stateJSON []byte) (*kernel.ThreadGroup, *hookOutput, error) {
+	stdin, stdinW, err := l.newHookPipe(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer stdin.DecRef(ctx)
+	stdoutR, stdout, err := l.newHookPipe(ctx)
+	if err != nil {
+		stdinW.DecRef(ctx)
+		return nil, nil, err
+	}
+	defer stdout.DecRef(ctx)
+
+	// The state fits in the pipe buffer. Closing the write end lets the hook
+	// read EOF after it.
+	_, err = stdinW.write(ctx, stateJSON)
+	stdinW.DecRef(ctx)
+	if err != nil {
+		stdoutR.DecRef(ctx)
+		return nil, nil, fmt.Errorf("writing state: %v", err)
+	}
+
+	fdTable := l.k.NewFDTable()
+	// CreateProcess takes a reference on fdTable if successful.
+	defer fdTable.DecRef(ctx)
+	for fd, f := range []hookPipeEnd{stdin, stdout, stdout} {
+		if err := f.install(ctx, fdTable, int32(fd)); err != nil {
+			stdoutR.DecRef(ctx)
+			return nil, nil, err
+		}
+	}
+	procArgs.FDTable = fdTable
+
+	tg, _, err := l.k.CreateProcess(*procArgs)
+	if err != nil {
+		stdoutR.DecRef(ctx)
+		return nil, nil, err
+	}
+	output := &hookOutput{done: make(chan struct{})}
+	go output.drain(ctx, stdoutR) // S/R-SAFE: hooks run before the container starts.
+	l.k.StartProcess(tg)
+	return tg, output, nil
+}
+
+// hookPipeEnd is an end of a sentry pipe, for either VFS.
+type hookPipeEnd interface {
+	EventRegister(e *waiter.Entry, mask waiter.EventMask)
+	EventUnregister(e *waiter.Entry)
+	DecRef(ctx context.Context)
+
+	read(ctx context.Context, buf []byte) (int64, error)
+	write(ctx context.Context, buf []byte) (int64, error)
+	install(ctx context.Context, fdTable *kernel.FDTable, fd int32) error
+}
+
+// newHookPipe returns the read and write ends of a new pipe.
+func (l *Loader) newHookPipe(ctx context.Context) (hookPipeEnd, hookPipeEnd, error) {
+	if kernel.VFS2Enabled {
+		r, w, err := pipefs.NewConnectedPipeFDs(ctx, l.k.PipeMount(), 0)
+		if err != nil {
+			return nil, nil, err
+		}
+		return hookPipeEndVFS2{r}, hookPipeEndVFS2{w}, nil
+	}
+	r, w := pipe.NewConnectedPipe(ctx, pipe.DefaultPipeSize)
+	return hookPipeEndVFS1{r}, hookPipeEndVFS1{w}, nil
+}
+
+type hookPipeEndVFS1 struct {
+	*fs.File
+}
+
+func (p hookPipeEndVFS1) read(ctx context.Context, buf []byte) (int64, error) {
+	return p.Readv(ctx, usermem.BytesIOSequence(buf))
+}
+
+func (p hookPipeEndVFS1) write(ctx context.Context, buf []byte) (int64, error) {
+	return p.Writev(ctx, usermem.BytesIOSequence(buf))
+}
+
+func (p hookPipeEndVFS1) install(ctx context.Context, fdTable *kernel.FDTable, fd int32) error {
+	return fdTable.NewFDAt(ctx, fd, p.File, kernel.FDFlags{})
+}
+
+type hookPipeEndVFS2 struct {
+	*vfs.FileDescription
+}
+
+func (p hookPipeEndVFS2) read(ctx context.Context, buf []byte) (int64, error) {
+	return p.Read(ctx, usermem.BytesIOSequence(buf), vfs.ReadOptions{})
+}
+
+func (p hookPipeEndVFS2) write(ctx context.Context, buf []byte) (int64, error) {
+	return p.Write(ctx, usermem.BytesIOSequence(buf), vfs.WriteOptions{})
+}
+
+func (p hookPipeEndVFS2) install(ctx context.Context, fdTable *kernel.FDTable, fd int32) error {
+	return fdTable.NewFDAtVFS2(ctx, fd, p.FileDescription, kernel.FDFlags{})
+}
+
+// hookOutput keeps the beginning of the output of a hook.
+type hookOutput struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+
+	// done is closed when the output has been read to the end.
+	done chan struct{}
+}
+
+// drain reads r until all its writers are closed, and releases it.
+func (o *hookOutput) drain(ctx context.Context, r hookPipeEnd) {
+	defer close(o.done)
+	defer r.DecRef(ctx)
+	e, ch := waiter.NewChannelEntry(nil)
+	r.EventRegister(&e, waiter.EventIn|waiter.EventHUp)
+	defer r.EventUnregister(&e)
+
+	buf := make([]byte, 512)
+	for {
+		n, err := r.read(ctx, buf)
+		if n > 0 {
+			o.mu.Lock()
+			if rem := hookOutputLimit - o.buf.Len(); rem > 0 {
+				if int(n) > rem {
+					n = int64(rem)
+				}
+				o.buf.Write(buf[:n])
+			}
+			o.mu.Unlock()
+		}
+		if err == syserror.ErrWouldBlock {
+			<-ch
+			continue
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+// wait waits for the output to be read to the end, after the hook exited. It
+// gives up after a second, in case the hook left processes that hold its
+// stdout or stderr open.
+func (o *hookOutput) wait() {
+	select {
+	case <-o.done:
+	case <-time.After(time.Second):
+	}
+}
+
+// String implements fmt.Stringer.
+func (o *hookOutput) String() string {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return o.buf.String()
+}
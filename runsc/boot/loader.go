@@ -21,6 +21,7 @@ import (
 	mrand "math/rand"
 	"os"
 	"runtime"
+	"strings"
 	"sync/atomic"
 	gtime "time"
 
@@ -33,16 +34,22 @@ import (
 	"gvisor.dev/gvisor/pkg/fd"
 	"gvisor.dev/gvisor/pkg/log"
 	"gvisor.dev/gvisor/pkg/memutil"
+	"gvisor.dev/gvisor/pkg/otlp"
+	"gvisor.dev/gvisor/pkg/p9"
 	"gvisor.dev/gvisor/pkg/rand"
 	"gvisor.dev/gvisor/pkg/refs"
 	"gvisor.dev/gvisor/pkg/refsvfs2"
 	"gvisor.dev/gvisor/pkg/sentry/arch"
 	"gvisor.dev/gvisor/pkg/sentry/control"
+	"gvisor.dev/gvisor/pkg/sentry/devices/attestdev"
+	"gvisor.dev/gvisor/pkg/sentry/devices/kfddev"
 	"gvisor.dev/gvisor/pkg/sentry/fdimport"
 	"gvisor.dev/gvisor/pkg/sentry/fs"
 	"gvisor.dev/gvisor/pkg/sentry/fs/host"
 	"gvisor.dev/gvisor/pkg/sentry/fs/user"
+	gofervfs2 "gvisor.dev/gvisor/pkg/sentry/fsimpl/gofer"
 	hostvfs2 "gvisor.dev/gvisor/pkg/sentry/fsimpl/host"
+	"gvisor.dev/gvisor/pkg/sentry/fsimpl/overlay"
 	"gvisor.dev/gvisor/pkg/sentry/inet"
 	"gvisor.dev/gvisor/pkg/sentry/kernel"
 	"gvisor.dev/gvisor/pkg/sentry/kernel/auth"
@@ -51,6 +58,8 @@ import (
 	"gvisor.dev/gvisor/pkg/sentry/platform"
 	"gvisor.dev/gvisor/pkg/sentry/sighandling"
 	"gvisor.dev/gvisor/pkg/sentry/socket/netfilter"
+	"gvisor.dev/gvisor/pkg/sentry/state"
+	"gvisor.dev/gvisor/pkg/sentry/strace"
 	"gvisor.dev/gvisor/pkg/sentry/syscalls/linux/vfs2"
 	"gvisor.dev/gvisor/pkg/sentry/time"
 	"gvisor.dev/gvisor/pkg/sentry/usage"
@@ -81,6 +90,8 @@ import (
 
 	// Include other supported socket providers.
 	_ "gvisor.dev/gvisor/pkg/sentry/socket/netlink"
+	_ "gvisor.dev/gvisor/pkg/sentry/socket/netlink/audit"
+	_ "gvisor.dev/gvisor/pkg/sentry/socket/netlink/netfilter"
 	_ "gvisor.dev/gvisor/pkg/sentry/socket/netlink/route"
 	_ "gvisor.dev/gvisor/pkg/sentry/socket/netlink/uevent"
 	_ "gvisor.dev/gvisor/pkg/sentry/socket/unix"
@@ -98,6 +109,10 @@ type containerInfo struct {
 	// stdioFDs contains stdin, stdout, and stderr.
 	stdioFDs []*fd.FD
 
+	// listenFDs are the files passed to the container by socket activation,
+	// which are installed from FD 3.
+	listenFDs []*fd.FD
+
 	// goferFDs are the FDs that attach the sandbox to the gofers.
 	goferFDs []*fd.FD
 }
@@ -138,6 +153,31 @@ type Loader struct {
 	// mountHints provides extra information about mounts for containers that
 	// apply to the entire pod.
 	mountHints *podMountHints
+
+	// overlayUpper, if not nil, persists the upper layer of the root
+	// container's root overlay in a host file.
+	overlayUpper *overlay.UpperStore
+
+	// tmpfsSpillFD is the FD of the host file that backs the kernel's spill
+	// MemoryFile, or 0 if there is none.
+	tmpfsSpillFD int
+
+	// goferReadCache, if not nil, caches the contents of files read from
+	// gofer mounts that the sandbox doesn't write to.
+	goferReadCache *gofervfs2.ReadCache
+
+	// proxiedIoctls maps the FDs of host devices that are proxied to the
+	// sandbox (see package devproxy) to the ioctl requests that are
+	// forwarded to them.
+	proxiedIoctls map[int][]uint32
+
+	// profileRing, if not nil, holds the profiles collected by continuous
+	// profiling.
+	profileRing *control.ProfileRing
+
+	// watchdogCheckpointFile, if not nil, is the host file to which the
+	// watchdog checkpoints the sandbox before panicking.
+	watchdogCheckpointFile *os.File
 }
 
 // execID uniquely identifies a sentry process that is executed in a container.
@@ -191,21 +231,92 @@ type Args struct {
 	// StdioFDs is the stdio for the application. The Loader takes ownership of
 	// these FDs and may close them at any time.
 	StdioFDs []int
+	// ListenFDs are the files passed to the root container by socket
+	// activation. The Loader takes ownership of these FDs and may close them
+	// at any time.
+	ListenFDs []int
 	// NumCPU is the number of CPUs to create inside the sandbox.
 	NumCPU int
+	// MaxCPU is the number of CPUs that the sandbox may be resized to. If it
+	// is less than NumCPU, the sandbox can't grow beyond NumCPU CPUs.
+	MaxCPU int
 	// TotalMem is the initial amount of total memory to report back to the
 	// container.
 	TotalMem uint64
 	// UserLogFD is the file descriptor to write user logs to.
 	UserLogFD int
+	// OverlayUpperFD is the FD of the host file in which the upper layer of
+	// the root overlay is persisted, or 0 if it is kept in memory. The Loader
+	// takes ownership of this FD.
+	OverlayUpperFD int
+	// TmpfsSpillFD is the FD of the host file in which tmpfs data is spilled,
+	// or 0 if tmpfs data is kept in memory. The Loader takes ownership of
+	// this FD.
+	TmpfsSpillFD int
+	// GoferReadCacheFD is the FD of the host file in which the contents of
+	// files read from gofer mounts are cached, or 0 if there is no such
+	// cache. The Loader takes ownership of this FD.
+	GoferReadCacheFD int
+	// AttestationDevice is the name of the host's confidential computing
+	// attestation device to expose to the sandbox (see package attestdev),
+	// or empty if there is none.
+	AttestationDevice string
+	// AttestationFD is the FD of the device named by AttestationDevice. The
+	// Loader takes ownership of this FD.
+	AttestationFD int
+	// KFDFD is the FD of the host's /dev/kfd, to expose to the sandbox, or 0
+	// if there is none. The Loader takes ownership of this FD.
+	KFDFD int
+	// OTLPTraceFD is the FD of the host file to which OpenTelemetry spans are
+	// exported, or 0 if spans aren't exported.
+	OTLPTraceFD int
+	// ProfileRingFD is the FD of the host file in which continuous profiles
+	// are kept, or 0 if continuous profiling is disabled. The Loader takes
+	// ownership of this FD.
+	ProfileRingFD int
+	// CoreDumpFD is the FD of the pipe to the host command given by
+	// Conf.CorePattern, or 0 if core dumps aren't piped.
+	CoreDumpFD int
+	// WatchdogCheckpointFD is the FD of the host file given by
+	// Conf.WatchdogCheckpoint, or 0 if there is none. The Loader takes
+	// ownership of this FD.
+	WatchdogCheckpointFD int
 }
 
 // make sure stdioFDs are always the same on initial start and on restore
 const startingStdioFD = 256
 
+// checkOptionalFDs checks the FDs in args that may be omitted. As with
+// UserLogFD, such an FD is 0 if it isn't passed: FDs 0 to 2 are the stdio of
+// the boot process, and are never donated to the Loader.
+func checkOptionalFDs(args *Args) error {
+	for _, opt := range []struct {
+		name string
+		fd   int
+	}{
+		{"tmpfs spill", args.TmpfsSpillFD},
+		{"overlay upper", args.OverlayUpperFD},
+		{"gofer read cache", args.GoferReadCacheFD},
+		{"/dev/kfd", args.KFDFD},
+		{"OTLP trace", args.OTLPTraceFD},
+		{"core dump", args.CoreDumpFD},
+		{"profile ring", args.ProfileRingFD},
+		{"watchdog checkpoint", args.WatchdogCheckpointFD},
+	} {
+		if opt.fd != 0 && opt.fd <= 2 {
+			return fmt.Errorf("invalid %s FD %d", opt.name, opt.fd)
+		}
+	}
+	return nil
+}
+
 // New initializes a new kernel loader configured by spec.
 // New also handles setting up a kernel for restoring a container.
 func New(args Args) (*Loader, error) {
+	if err := checkOptionalFDs(&args); err != nil {
+		return nil, err
+	}
+
 	// We initialize the rand package now to make sure /dev/urandom is pre-opened
 	// on kernels that do not support getrandom(2).
 	if err := rand.Init(); err != nil {
@@ -222,10 +333,34 @@ func New(args Args) (*Loader, error) {
 		if args.Conf.FUSE {
 			kernel.FUSEEnabled = true
 		}
+		vfs.RecordMountStats = args.Conf.FSStats
+		vfs.SlowOpThreshold = gtime.Duration(args.Conf.FSSlowOpMS) * gtime.Millisecond
+		scmRights, err := hostvfs2.ParseSCMRightsPolicy(args.Conf.HostUDSSCMRights)
+		if err != nil {
+			return nil, fmt.Errorf("invalid host-uds-scm-rights flag: %v", err)
+		}
+		hostvfs2.AllowedSCMRights = scmRights
 
 		vfs2.Override()
 	}
 
+	if args.OTLPTraceFD != 0 {
+		traceFile := os.NewFile(uintptr(args.OTLPTraceFD), "otlp trace file")
+		exporter := otlp.NewExporter(traceFile,
+			otlp.String("service.name", "runsc"),
+			otlp.String("runsc.command", "boot"),
+			otlp.String("gvisor.sandbox.id", args.ID))
+		otlp.SetDefault(otlp.NewTracer(exporter, specutils.TraceParent(args.Spec, args.ID)))
+		kernel.SyscallSpanThreshold = gtime.Duration(args.Conf.OTLPSyscallThresholdUS) * gtime.Microsecond
+		p9.ClientSpans = args.Conf.OTLPGoferRPCs
+	}
+
+	if args.Conf.SyscallStats {
+		if err := kernel.EnableSyscallStats(); err != nil {
+			return nil, fmt.Errorf("enabling syscall statistics: %v", err)
+		}
+	}
+
 	// Create kernel and platform.
 	p, err := createPlatform(args.Conf, args.Device)
 	if err != nil {
@@ -234,13 +369,39 @@ func New(args Args) (*Loader, error) {
 	k := &kernel.Kernel{
 		Platform: p,
 	}
+	coreDumps := kernel.CoreDumpOptions{Pattern: args.Conf.CorePattern}
+	if args.CoreDumpFD != 0 {
+		coreDumps.Pipe = os.NewFile(uintptr(args.CoreDumpFD), "core dump pipe")
+	}
+	k.SetCoreDumpOptions(coreDumps)
 
 	// Create memory file.
-	mf, err := createMemoryFile()
+	mf, err := createMemoryFile(args.Conf)
 	if err != nil {
 		return nil, fmt.Errorf("creating memory file: %v", err)
 	}
 	k.SetMemoryFile(mf)
+	if args.TmpfsSpillFD != 0 {
+		spillMF, err := createSpillMemoryFile(args.TmpfsSpillFD)
+		if err != nil {
+			return nil, fmt.Errorf("creating spill memory file: %v", err)
+		}
+		k.SetSpillMemoryFile(spillMF)
+	}
+	if args.Conf.IdleReclaimSec != 0 {
+		ir, ok := p.(idleReclaimer)
+		if !ok {
+			return nil, fmt.Errorf("platform %q does not support idle memory reclaim", args.Conf.Platform)
+		}
+		ir.StartIdleReclaim(gtime.Duration(args.Conf.IdleReclaimSec)*gtime.Second, func() {
+			// Page cache contents are evictable; drop them so that the
+			// memory file's reclaimer can return their pages to the host.
+			k.MemoryFile().StartEvictions()
+			if spillMF := k.SpillMemoryFile(); spillMF != nil {
+				spillMF.StartEvictions()
+			}
+		})
+	}
 
 	// Create VDSO.
 	//
@@ -261,12 +422,6 @@ func New(args Args) (*Loader, error) {
 		return nil, fmt.Errorf("enabling strace: %v", err)
 	}
 
-	// Create root network namespace/stack.
-	netns, err := newRootNetworkNamespace(args.Conf, k, k)
-	if err != nil {
-		return nil, fmt.Errorf("creating network: %v", err)
-	}
-
 	// Create capabilities.
 	caps, err := specutils.Capabilities(args.Conf.EnableRaw, args.Spec.Process.Capabilities)
 	if err != nil {
@@ -287,27 +442,50 @@ func New(args Args) (*Loader, error) {
 		caps,
 		auth.NewRootUserNamespace())
 
+	// Create root network namespace/stack.
+	netns, err := newRootNetworkNamespace(args.Conf, k, k, creds.UserNamespace)
+	if err != nil {
+		return nil, fmt.Errorf("creating network: %v", err)
+	}
+
 	if args.NumCPU == 0 {
 		args.NumCPU = runtime.NumCPU()
 	}
 	log.Infof("CPUs: %d", args.NumCPU)
 	runtime.GOMAXPROCS(args.NumCPU)
+	appCores := args.NumCPU
+	if args.MaxCPU > appCores {
+		log.Infof("Maximum CPUs: %d", args.MaxCPU)
+		appCores = args.MaxCPU
+	}
 
 	if args.TotalMem > 0 {
 		// Adjust the total memory returned by the Sentry so that applications that
 		// use /proc/meminfo can make allocations based on this limit.
-		usage.MaximumTotalMemoryBytes = args.TotalMem
+		atomic.StoreUint64(&usage.MaximumTotalMemoryBytes, args.TotalMem)
 		log.Infof("Setting total memory to %.2f GB", float64(args.TotalMem)/(1<<30))
 	}
 
+	// Hide CPU features that the platform can't provide to applications.
+	featureSet := cpuid.HostFeatureSet()
+	if ff, ok := p.(featureSetFilter); ok {
+		ff.FilterFeatureSet(featureSet)
+	}
+	if args.Conf.CPUFeatures != "" {
+		if err := featureSet.Restrict(strings.Split(args.Conf.CPUFeatures, ",")); err != nil {
+			return nil, fmt.Errorf("restricting CPU features: %v", err)
+		}
+	}
+
 	// Initiate the Kernel object, which is required by the Context passed
 	// to createVFS in order to mount (among other things) procfs.
 	if err = k.Init(kernel.InitKernelArgs{
-		FeatureSet:                  cpuid.HostFeatureSet(),
+		FeatureSet:                  featureSet,
 		Timekeeper:                  tk,
 		RootUserNamespace:           creds.UserNamespace,
 		RootNetworkNamespace:        netns,
-		ApplicationCores:            uint(args.NumCPU),
+		ApplicationCores:            uint(appCores),
+		OnlineCores:                 uint(args.NumCPU),
 		Vdso:                        vdso,
 		RootUTSNamespace:            kernel.NewUTSNamespace(args.Spec.Hostname, args.Spec.Hostname, creds.UserNamespace),
 		RootIPCNamespace:            kernel.NewIPCNamespace(creds.UserNamespace),
@@ -318,7 +496,7 @@ func New(args Args) (*Loader, error) {
 	}
 
 	if kernel.VFS2Enabled {
-		if err := registerFilesystems(k); err != nil {
+		if err := registerFilesystems(k, &args); err != nil {
 			return nil, fmt.Errorf("registering filesystems: %w", err)
 		}
 	}
@@ -337,9 +515,11 @@ func New(args Args) (*Loader, error) {
 	}
 
 	// Create a watchdog.
-	dogOpts := watchdog.DefaultOpts
-	dogOpts.TaskTimeoutAction = args.Conf.WatchdogAction
-	dog := watchdog.New(k, dogOpts)
+	var watchdogCheckpointFile *os.File
+	if args.WatchdogCheckpointFD != 0 {
+		watchdogCheckpointFile = os.NewFile(uintptr(args.WatchdogCheckpointFD), "watchdog checkpoint")
+	}
+	dog := watchdog.New(k, watchdogOpts(args.Conf, k, watchdogCheckpointFile))
 
 	procArgs, err := createProcessArgs(args.ID, args.Spec, creds, k, k.RootPIDNamespace())
 	if err != nil {
@@ -399,15 +579,64 @@ func New(args Args) (*Loader, error) {
 	for _, goferFD := range args.GoferFDs {
 		info.goferFDs = append(info.goferFDs, fd.New(goferFD))
 	}
+	for _, listenFD := range args.ListenFDs {
+		info.listenFDs = append(info.listenFDs, fd.New(listenFD))
+		// This is checked now, since the socket options needed aren't
+		// allowed once seccomp filters are installed.
+		if err := checkListenFD(listenFD); err != nil {
+			return nil, fmt.Errorf("socket activation FD %d: %w", len(info.listenFDs)+2, err)
+		}
+	}
+
+	var overlayUpper *overlay.UpperStore
+	if args.OverlayUpperFD != 0 {
+		overlayUpper, err = overlay.NewUpperStore(fd.New(args.OverlayUpperFD))
+		if err != nil {
+			return nil, fmt.Errorf("opening overlay upper store: %w", err)
+		}
+	}
+
+	var goferReadCache *gofervfs2.ReadCache
+	if args.GoferReadCacheFD != 0 {
+		goferReadCache, err = gofervfs2.NewReadCache(fd.New(args.GoferReadCacheFD))
+		if err != nil {
+			return nil, fmt.Errorf("opening gofer read cache: %w", err)
+		}
+	}
+
+	var profileRing *control.ProfileRing
+	if args.ProfileRingFD != 0 {
+		profileRing, err = control.NewProfileRing(os.NewFile(uintptr(args.ProfileRingFD), "profile ring"), int64(args.Conf.ProfileRingSize))
+		if err != nil {
+			return nil, fmt.Errorf("opening profile ring: %w", err)
+		}
+	}
 
 	eid := execID{cid: args.ID}
 	l := &Loader{
-		k:          k,
-		watchdog:   dog,
-		sandboxID:  args.ID,
-		processes:  map[execID]*execProcess{eid: {}},
-		mountHints: mountHints,
-		root:       info,
+		k:              k,
+		watchdog:       dog,
+		sandboxID:      args.ID,
+		processes:      map[execID]*execProcess{eid: {}},
+		mountHints:     mountHints,
+		root:           info,
+		overlayUpper:   overlayUpper,
+		tmpfsSpillFD:   args.TmpfsSpillFD,
+		goferReadCache: goferReadCache,
+		proxiedIoctls:  make(map[int][]uint32),
+		profileRing:    profileRing,
+
+		watchdogCheckpointFile: watchdogCheckpointFile,
+	}
+	if args.AttestationDevice != "" {
+		spec, err := attestdev.Spec(args.AttestationDevice)
+		if err != nil {
+			return nil, err
+		}
+		l.proxiedIoctls[args.AttestationFD] = spec.Requests()
+	}
+	if args.KFDFD != 0 {
+		l.proxiedIoctls[args.KFDFD] = kfddev.Spec().Requests()
 	}
 
 	// We don't care about child signals; some platforms can generate a
@@ -485,6 +714,16 @@ func (l *Loader) Destroy() {
 	}
 	l.watchdog.Stop()
 
+	// Persist the root overlay's upper layer before its filesystem is
+	// released. Applications have exited at this point, so it can't change.
+	if l.overlayUpper != nil {
+		ctx := l.k.SupervisorContext()
+		if err := l.overlayUpper.Flush(ctx); err != nil {
+			log.Warningf("Failed to flush overlay upper layer: %v", err)
+		}
+		l.overlayUpper.Release(ctx)
+	}
+
 	// Release all kernel resources. This is only safe after we can no longer
 	// save/restore.
 	l.k.Release()
@@ -502,6 +741,30 @@ func (l *Loader) Destroy() {
 	for _, fd := range l.root.goferFDs {
 		_ = fd.Close()
 	}
+	for _, fd := range l.root.listenFDs {
+		_ = fd.Close()
+	}
+	if l.tmpfsSpillFD != 0 {
+		_ = unix.Close(l.tmpfsSpillFD)
+	}
+	if l.goferReadCache != nil {
+		l.goferReadCache.Release()
+	}
+}
+
+// idleReclaimer is implemented by platforms that can return memory to the host
+// while the sandbox is idle.
+type idleReclaimer interface {
+	// StartIdleReclaim starts returning memory to the host, after calling
+	// reclaim, whenever application code hasn't run for period.
+	StartIdleReclaim(period gtime.Duration, reclaim func())
+}
+
+// featureSetFilter is implemented by platforms that can't run applications
+// with all host CPU features.
+type featureSetFilter interface {
+	// FilterFeatureSet removes unsupported features from fs.
+	FilterFeatureSet(fs *cpuid.FeatureSet)
 }
 
 func createPlatform(conf *config.Config, deviceFile *os.File) (platform.Platform, error) {
@@ -513,17 +776,29 @@ func createPlatform(conf *config.Config, deviceFile *os.File) (platform.Platform
 	return p.New(deviceFile)
 }
 
-func createMemoryFile() (*pgalloc.MemoryFile, error) {
+func createMemoryFile(conf *config.Config) (*pgalloc.MemoryFile, error) {
 	const memfileName = "runsc-memory"
-	memfd, err := memutil.CreateMemFD(memfileName, 0)
+	// We can't enable pgalloc.MemoryFileOpts.UseHostMemcgPressure even if
+	// there are memory cgroups specified, because at this point we're already
+	// in a mount namespace in which the relevant cgroupfs is not visible.
+	var opts pgalloc.MemoryFileOpts
+	memfdFlags := 0
+	switch conf.Hugepages {
+	case "thp":
+		opts.AdviseHugepage = true
+	case "2m":
+		memfdFlags = unix.MFD_HUGETLB | unix.MFD_HUGE_2MB
+		opts.HugepageSize = 2 << 20
+	case "1g":
+		memfdFlags = unix.MFD_HUGETLB | unix.MFD_HUGE_1GB
+		opts.HugepageSize = 1 << 30
+	}
+	memfd, err := memutil.CreateMemFD(memfileName, memfdFlags)
 	if err != nil {
 		return nil, fmt.Errorf("error creating memfd: %v", err)
 	}
 	memfile := os.NewFile(uintptr(memfd), memfileName)
-	// We can't enable pgalloc.MemoryFileOpts.UseHostMemcgPressure even if
-	// there are memory cgroups specified, because at this point we're already
-	// in a mount namespace in which the relevant cgroupfs is not visible.
-	mf, err := pgalloc.NewMemoryFile(memfile, pgalloc.MemoryFileOpts{})
+	mf, err := pgalloc.NewMemoryFile(memfile, opts)
 	if err != nil {
 		memfile.Close()
 		return nil, fmt.Errorf("error creating pgalloc.MemoryFile: %v", err)
@@ -531,6 +806,23 @@ func createMemoryFile() (*pgalloc.MemoryFile, error) {
 	return mf, nil
 }
 
+// createSpillMemoryFile returns a MemoryFile backed by a duplicate of the
+// given host file descriptor, which must refer to a file on a filesystem that
+// supports hole punching.
+func createSpillMemoryFile(fd int) (*pgalloc.MemoryFile, error) {
+	spillFD, err := unix.Dup(fd)
+	if err != nil {
+		return nil, fmt.Errorf("error duplicating spill file FD: %v", err)
+	}
+	spillFile := os.NewFile(uintptr(spillFD), "runsc-tmpfs-spill")
+	mf, err := pgalloc.NewMemoryFile(spillFile, pgalloc.MemoryFileOpts{})
+	if err != nil {
+		spillFile.Close()
+		return nil, fmt.Errorf("error creating pgalloc.MemoryFile: %v", err)
+	}
+	return mf, nil
+}
+
 // installSeccompFilters installs sandbox seccomp filters with the host.
 func (l *Loader) installSeccompFilters() error {
 	if l.root.conf.DisableSeccomp {
@@ -540,7 +832,9 @@ func (l *Loader) installSeccompFilters() error {
 			Platform:      l.k.Platform,
 			HostNetwork:   l.root.conf.Network == config.NetworkHost,
 			ProfileEnable: l.root.conf.ProfileEnable,
+			HostUDSCreate: l.root.conf.FSGoferHostUDSCreate,
 			ControllerFD:  l.ctrl.srv.FD(),
+			ProxiedIoctls: l.proxiedIoctls,
 		}
 		if err := filter.Install(opts); err != nil {
 			return fmt.Errorf("installing seccomp filters: %v", err)
@@ -633,9 +927,27 @@ func (l *Loader) run() error {
 		}
 	})
 
+	// Hold the init process until the hooks that run in the container are
+	// done, since they need the kernel running.
+	hooks := !l.restore && hasContainerHooks(l.root.spec)
+	if hooks {
+		ep.tg.Leader().BeginExternalStop()
+	}
+
 	log.Infof("Process should have started...")
 	l.watchdog.Start()
-	return l.k.Start()
+	if err := l.k.Start(); err != nil {
+		return err
+	}
+	if hooks {
+		// Hooks may run for a long time, or forever if they have no
+		// timeout, so they must not block the operations that clean up
+		// the container, e.g. signal and destroyContainer.
+		l.mu.Unlock()
+		defer l.mu.Lock()
+		return l.runContainerHooks(l.sandboxID, l.root.spec, ep.tg)
+	}
+	return nil
 }
 
 // createContainer creates a new container inside the sandbox.
@@ -728,10 +1040,22 @@ func (l *Loader) startContainer(spec *specs.Spec, conf *config.Config, cid strin
 		info.stdioFDs = stdioFDs
 	}
 
+	if err := setContainerStrace(cid, l.root.conf, conf); err != nil {
+		return fmt.Errorf("enabling strace: %v", err)
+	}
+
 	ep.tg, ep.tty, ep.ttyVFS2, err = l.createContainerProcess(false, cid, info)
 	if err != nil {
 		return err
 	}
+	if hasContainerHooks(spec) {
+		ep.tg.Leader().BeginExternalStop()
+		l.k.StartProcess(ep.tg)
+		// See run.
+		l.mu.Unlock()
+		defer l.mu.Lock()
+		return l.runContainerHooks(cid, spec, ep.tg)
+	}
 	l.k.StartProcess(ep.tg)
 	return nil
 }
@@ -746,12 +1070,17 @@ func (l *Loader) createContainerProcess(root bool, cid string, info *containerIn
 	// CreateProcess takes a reference on fdTable if successful. We won't need
 	// ours either way.
 	info.procArgs.FDTable = fdTable
+	if err := l.importListenFDs(ctx, fdTable, info.listenFDs); err != nil {
+		return nil, nil, nil, fmt.Errorf("importing socket activation fds: %v", err)
+	}
 
 	// Setup the child container file system.
 	l.startGoferMonitor(cid, info.goferFDs)
 
 	mntr := newContainerMounter(info.spec, info.goferFDs, l.k, l.mountHints)
+	mntr.goferReadCache = l.goferReadCache
 	if root {
+		mntr.overlayUpper = l.overlayUpper
 		if err := mntr.processHints(info.conf, info.procArgs.Credentials); err != nil {
 			return nil, nil, nil, err
 		}
@@ -900,6 +1229,8 @@ func (l *Loader) destroyContainer(cid string) error {
 			delete(l.processes, key)
 		}
 	}
+	l.k.TaskSet().ForgetContainerUsage(cid)
+	strace.ClearContainerFilter(cid)
 
 	log.Debugf("Container destroyed, cid: %s", cid)
 	return nil
@@ -1064,7 +1395,7 @@ func (l *Loader) WaitExit() kernel.ExitStatus {
 	return l.k.GlobalInit().ExitStatus()
 }
 
-func newRootNetworkNamespace(conf *config.Config, clock tcpip.Clock, uniqueID stack.UniqueID) (*inet.Namespace, error) {
+func newRootNetworkNamespace(conf *config.Config, clock tcpip.Clock, uniqueID stack.UniqueID, userns *auth.UserNamespace) (*inet.Namespace, error) {
 	// Create an empty network stack because the network namespace may be empty at
 	// this point. Netns is configured before Run() is called. Netstack is
 	// configured using a control uRPC message. Host network is configured inside
@@ -1072,18 +1403,24 @@ func newRootNetworkNamespace(conf *config.Config, clock tcpip.Clock, uniqueID st
 	switch conf.Network {
 	case config.NetworkHost:
 		// No network namespacing support for hostinet yet, hence creator is nil.
-		return inet.NewRootNamespace(hostinet.NewStack(), nil), nil
+		return inet.NewRootNamespace(hostinet.NewStack(), nil, userns), nil
 
 	case config.NetworkNone, config.NetworkSandbox:
 		s, err := newEmptySandboxNetworkStack(clock, uniqueID)
 		if err != nil {
 			return nil, err
 		}
+		if conf.TCPRestoreWindowSec != 0 {
+			opt := tcpip.TCPRestoreWindowOption(gtime.Duration(conf.TCPRestoreWindowSec) * gtime.Second)
+			if err := s.(*netstack.Stack).Stack.SetTransportProtocolOption(tcp.ProtocolNumber, &opt); err != nil {
+				return nil, fmt.Errorf("SetTransportProtocolOption(%d, &%T(%v)): %s", tcp.ProtocolNumber, opt, opt, err)
+			}
+		}
 		creator := &sandboxNetstackCreator{
 			clock:    clock,
 			uniqueID: uniqueID,
 		}
-		return inet.NewRootNamespace(s, creator), nil
+		return inet.NewRootNamespace(s, creator, userns), nil
 
 	default:
 		panic(fmt.Sprintf("invalid network configuration: %v", conf.Network))
@@ -1110,6 +1447,9 @@ func newEmptySandboxNetworkStack(clock tcpip.Clock, uniqueID stack.UniqueID) (in
 		RawFactory: raw.EndpointFactory{},
 		UniqueID:   uniqueID,
 		IPTables:   netfilter.DefaultLinuxTables(),
+		// Neighbor entries are managed through the neighbor cache, e.g.
+		// by RTM_NEWNEIGH netlink requests.
+		UseNeighborCache: true,
 	})}
 
 	// Enable SACK Recovery.
@@ -1346,3 +1686,107 @@ func createFDTable(ctx context.Context, console bool, stdioFDs []*fd.FD) (*kerne
 	}
 	return fdTable, ttyFile, ttyFileVFS2, nil
 }
+
+// checkListenFD returns an error if the file passed by socket activation can't
+// be used in the sandbox. Host Unix domain sockets can only be imported once
+// connected, so listening ones are rejected.
+func checkListenFD(hostFD int) error {
+	acceptConn, err := unix.GetsockoptInt(hostFD, unix.SOL_SOCKET, unix.SO_ACCEPTCONN)
+	if err != nil {
+		// Not a socket.
+		return nil
+	}
+	if acceptConn != 0 && !hostinet.CanImport(hostFD) {
+		return fmt.Errorf("listening sockets other than TCP sockets aren't supported")
+	}
+	return nil
+}
+
+// importListenFDs installs the files passed by socket activation in fdTable,
+// from FD 3 as sd_listen_fds(3) expects. TCP and UDP sockets are imported into
+// the host network stack, which must be in use; other files are imported like
+// stdio. Used FDs are either closed or released.
+func (l *Loader) importListenFDs(ctx context.Context, fdTable *kernel.FDTable, listenFDs []*fd.FD) error {
+	const listenFDsStart = 3
+	_, hostNetwork := l.k.RootNetworkNamespace().Stack().(*hostinet.Stack)
+	for i, hostFD := range listenFDs {
+		appFD := int32(listenFDsStart + i)
+		if hostinet.CanImport(hostFD.FD()) {
+			if !hostNetwork {
+				return fmt.Errorf("FD %d is a TCP or UDP socket, which requires --network=host", appFD)
+			}
+			if kernel.VFS2Enabled {
+				f, err := hostinet.ImportVFS2(ctx, hostFD.FD())
+				if err != nil {
+					return err
+				}
+				hostFD.Release()
+				err = fdTable.NewFDAtVFS2(ctx, appFD, f, kernel.FDFlags{})
+				f.DecRef(ctx)
+				if err != nil {
+					return err
+				}
+			} else {
+				f, err := hostinet.Import(ctx, hostFD.FD())
+				if err != nil {
+					return err
+				}
+				hostFD.Release()
+				err = fdTable.NewFDAt(ctx, appFD, f, kernel.FDFlags{})
+				f.DecRef(ctx)
+				if err != nil {
+					return err
+				}
+			}
+			continue
+		}
+
+		if kernel.VFS2Enabled {
+			f, err := hostvfs2.ImportFD(ctx, l.k.HostMount(), hostFD.FD(), false /* isTTY */)
+			if err != nil {
+				return fmt.Errorf("FD %d: %v", appFD, err)
+			}
+			hostFD.Release()
+			err = fdTable.NewFDAtVFS2(ctx, appFD, f, kernel.FDFlags{})
+			f.DecRef(ctx)
+			if err != nil {
+				return err
+			}
+		} else {
+			f, err := host.ImportFile(ctx, hostFD.FD(), false /* isTTY */)
+			if err != nil {
+				return fmt.Errorf("FD %d: %v", appFD, err)
+			}
+			_ = hostFD.Close() // FD is dup'd in ImportFile.
+			err = fdTable.NewFDAt(ctx, appFD, f, kernel.FDFlags{})
+			f.DecRef(ctx)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// watchdogOpts returns the options of the watchdog of k. If f is not nil, the
+// watchdog checkpoints the sandbox to it before panicking.
+func watchdogOpts(conf *config.Config, k *kernel.Kernel, f *os.File) watchdog.Opts {
+	opts := watchdog.DefaultOpts
+	opts.TaskTimeout = gtime.Duration(conf.WatchdogTaskTimeoutSec) * gtime.Second
+	opts.TaskTimeoutAction = conf.WatchdogAction
+	opts.StartupTimeout = gtime.Duration(conf.WatchdogStartupTimeoutSec) * gtime.Second
+	if f != nil {
+		opts.Checkpoint = func() error {
+			saveOpts := state.SaveOpts{
+				Destination: f,
+				// The watchdog panics after the save, so keep the kernel
+				// running until then.
+				Callback: func(error) {},
+			}
+			// The watchdog waits for the save, so it must not be stopped by
+			// it: give the save a disabled watchdog instead.
+			return saveOpts.Save(k.SupervisorContext(), k, watchdog.New(k, watchdog.Opts{}))
+		}
+	}
+	return opts
+}
@@ -148,6 +148,37 @@ func createLoader(vfsEnabled bool, spec *specs.Spec) (*Loader, func(), error) {
 	return l, cleanup, nil
 }
 
+// TestCheckOptionalFDs checks that optional FDs are omitted by default, and
+// that stdio FDs are rejected.
+func TestCheckOptionalFDs(t *testing.T) {
+	if err := checkOptionalFDs(&Args{}); err != nil {
+		t.Errorf("checkOptionalFDs with no optional FDs failed: %v", err)
+	}
+	for _, tc := range []struct {
+		name string
+		set  func(args *Args, fd int)
+	}{
+		{"tmpfs spill", func(args *Args, fd int) { args.TmpfsSpillFD = fd }},
+		{"overlay upper", func(args *Args, fd int) { args.OverlayUpperFD = fd }},
+		{"gofer read cache", func(args *Args, fd int) { args.GoferReadCacheFD = fd }},
+		{"/dev/kfd", func(args *Args, fd int) { args.KFDFD = fd }},
+		{"OTLP trace", func(args *Args, fd int) { args.OTLPTraceFD = fd }},
+		{"core dump", func(args *Args, fd int) { args.CoreDumpFD = fd }},
+		{"profile ring", func(args *Args, fd int) { args.ProfileRingFD = fd }},
+		{"watchdog checkpoint", func(args *Args, fd int) { args.WatchdogCheckpointFD = fd }},
+	} {
+		var args Args
+		tc.set(&args, 1)
+		if err := checkOptionalFDs(&args); err == nil {
+			t.Errorf("checkOptionalFDs with %s FD 1 succeeded", tc.name)
+		}
+		tc.set(&args, 3)
+		if err := checkOptionalFDs(&args); err != nil {
+			t.Errorf("checkOptionalFDs with %s FD 3 failed: %v", tc.name, err)
+		}
+	}
+}
+
 // TestRun runs a simple application in a sandbox and checks that it succeeds.
 func TestRun(t *testing.T) {
 	doRun(t, false)
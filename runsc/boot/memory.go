@@ -0,0 +1,160 @@
+// Copyright 2020 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package boot
+
+import (
+	"bytes"
+	"fmt"
+	"runtime"
+
+	"gvisor.dev/gvisor/pkg/sentry/kernel"
+	"gvisor.dev/gvisor/pkg/sentry/socket/netstack"
+	"gvisor.dev/gvisor/pkg/sentry/usage"
+	"gvisor.dev/gvisor/pkg/tcpip"
+)
+
+// MemoryBreakdown attributes the memory used by the sandbox, in bytes.
+type MemoryBreakdown struct {
+	// The following are allocated from the sentry's memory file, which backs
+	// application memory.
+
+	// Anonymous is the application's anonymous memory.
+	Anonymous uint64 `json:"anonymous"`
+
+	// PageCache is the cached contents of files without host FDs.
+	PageCache uint64 `json:"page_cache"`
+
+	// Tmpfs is the contents of tmpfs and ramdisk files.
+	Tmpfs uint64 `json:"tmpfs"`
+
+	// System is the memory used by the sentry on behalf of the application,
+	// such as page tables and memory that is being reclaimed.
+	System uint64 `json:"system"`
+
+	// MemoryFile is the amount of host memory committed to the memory file,
+	// which is the sum of the above.
+	MemoryFile uint64 `json:"memory_file"`
+
+	// Mapped is the memory of host files mapped into the application, which
+	// is in the host page cache.
+	Mapped uint64 `json:"mapped"`
+
+	// The following are allocated by the Go runtime.
+
+	// NetstackQueued is the data queued in netstack sockets of the root
+	// network namespace, which is part of GoHeapInuse.
+	NetstackQueued uint64 `json:"netstack_queued"`
+
+	// GoHeapInuse is the Go heap memory in use.
+	GoHeapInuse uint64 `json:"go_heap_inuse"`
+
+	// GoHeapIdle is the Go heap memory that is free, but not yet returned to
+	// the host.
+	GoHeapIdle uint64 `json:"go_heap_idle"`
+
+	// GoStacks is the memory of goroutine stacks.
+	GoStacks uint64 `json:"go_stacks"`
+
+	// GoRuntime is the memory of the Go runtime's own data structures, such
+	// as garbage collector metadata.
+	GoRuntime uint64 `json:"go_runtime"`
+
+	// GoTotal is the memory obtained from the host by the Go runtime, less
+	// what it returned to the host.
+	GoTotal uint64 `json:"go_total"`
+}
+
+// memoryBreakdown returns the MemoryBreakdown of the sandbox running k.
+func memoryBreakdown(k *kernel.Kernel) (*MemoryBreakdown, error) {
+	mf := k.MemoryFile()
+	if err := mf.UpdateUsage(); err != nil {
+		return nil, fmt.Errorf("updating memory usage: %v", err)
+	}
+	stats, _ := usage.MemoryAccounting.Copy()
+	committed, err := mf.TotalUsage()
+	if err != nil {
+		return nil, fmt.Errorf("getting memory file usage: %v", err)
+	}
+	mb := &MemoryBreakdown{
+		Anonymous:  stats.Anonymous,
+		PageCache:  stats.PageCache,
+		Tmpfs:      stats.Tmpfs + stats.Ramdiskfs,
+		System:     stats.System,
+		MemoryFile: committed,
+		Mapped:     stats.Mapped,
+	}
+
+	if s, ok := k.RootNetworkNamespace().Stack().(*netstack.Stack); ok {
+		mb.NetstackQueued = netstackQueued(s)
+	}
+
+	// This stops the world briefly.
+	var ms runtime.MemStats
+	runtime.ReadMemStats(&ms)
+	mb.GoHeapInuse = ms.HeapInuse
+	mb.GoHeapIdle = ms.HeapIdle - ms.HeapReleased
+	mb.GoStacks = ms.StackSys
+	mb.GoRuntime = ms.MSpanSys + ms.MCacheSys + ms.BuckHashSys + ms.GCSys + ms.OtherSys
+	mb.GoTotal = ms.Sys - ms.HeapReleased
+	return mb, nil
+}
+
+// netstackQueued returns the number of bytes queued for reading or sending in
+// the endpoints of s.
+func netstackQueued(s *netstack.Stack) uint64 {
+	var queued uint64
+	seen := make(map[uint64]struct{})
+	for _, ep := range s.Stack.RegisteredEndpoints() {
+		// Dual-stack endpoints are registered for each network protocol.
+		if _, ok := seen[ep.UniqueID()]; ok {
+			continue
+		}
+		seen[ep.UniqueID()] = struct{}{}
+		tep, ok := ep.(tcpip.Endpoint)
+		if !ok {
+			continue
+		}
+		for _, opt := range []tcpip.SockOptInt{tcpip.ReceiveQueueSizeOption, tcpip.SendQueueSizeOption} {
+			if v, err := tep.GetSockOptInt(opt); err == nil && v > 0 {
+				queued += uint64(v)
+			}
+		}
+	}
+	return queued
+}
+
+// String formats mb as a table, in kilobytes.
+func (mb *MemoryBreakdown) String() string {
+	var buf bytes.Buffer
+	row := func(name string, v uint64) {
+		fmt.Fprintf(&buf, "%-24s %10d kB\n", name, v>>10)
+	}
+	buf.WriteString("Memory file (application memory):\n")
+	row("  Anonymous", mb.Anonymous)
+	row("  Page cache", mb.PageCache)
+	row("  Tmpfs", mb.Tmpfs)
+	row("  System", mb.System)
+	row("  Total committed", mb.MemoryFile)
+	buf.WriteString("Host page cache:\n")
+	row("  Mapped host files", mb.Mapped)
+	buf.WriteString("Go runtime (sentry):\n")
+	row("  Heap in use", mb.GoHeapInuse)
+	row("    Netstack queued data", mb.NetstackQueued)
+	row("  Heap idle, not released", mb.GoHeapIdle)
+	row("  Goroutine stacks", mb.GoStacks)
+	row("  Runtime metadata", mb.GoRuntime)
+	row("  Total", mb.GoTotal)
+	return buf.String()
+}
@@ -0,0 +1,120 @@
+// Copyright 2020 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package boot
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"gvisor.dev/gvisor/pkg/sentry/socket/netstack"
+	"gvisor.dev/gvisor/pkg/tcpip"
+	"gvisor.dev/gvisor/pkg/tcpip/header"
+	"gvisor.dev/gvisor/pkg/tcpip/link/loopback"
+	"gvisor.dev/gvisor/pkg/tcpip/network/ipv4"
+	"gvisor.dev/gvisor/pkg/tcpip/network/ipv6"
+	"gvisor.dev/gvisor/pkg/tcpip/stack"
+	"gvisor.dev/gvisor/pkg/tcpip/transport/udp"
+	"gvisor.dev/gvisor/pkg/waiter"
+)
+
+func TestMemoryBreakdown(t *testing.T) {
+	l, cleanup, err := createLoader(true /* vfsEnabled */, testSpec())
+	if err != nil {
+		t.Fatalf("error creating loader: %v", err)
+	}
+	defer l.Destroy()
+	defer cleanup()
+
+	mb, err := memoryBreakdown(l.k)
+	if err != nil {
+		t.Fatalf("memoryBreakdown failed: %v", err)
+	}
+	if mb.GoHeapInuse == 0 {
+		t.Errorf("got no Go heap in use: %+v", mb)
+	}
+	// The Go runtime's memory is read at once, so its parts add up.
+	if sum := mb.GoHeapInuse + mb.GoHeapIdle + mb.GoStacks + mb.GoRuntime; sum != mb.GoTotal {
+		t.Errorf("Go runtime memory got parts adding up to %d, want total %d: %+v", sum, mb.GoTotal, mb)
+	}
+	if mb.NetstackQueued > mb.GoHeapInuse {
+		t.Errorf("got more netstack queued data than Go heap in use: %+v", mb)
+	}
+}
+
+func TestNetstackQueued(t *testing.T) {
+	s := stack.New(stack.Options{
+		NetworkProtocols:   []stack.NetworkProtocolFactory{ipv4.NewProtocol, ipv6.NewProtocol},
+		TransportProtocols: []stack.TransportProtocolFactory{udp.NewProtocol},
+	})
+	if err := s.CreateNIC(1, loopback.New()); err != nil {
+		t.Fatalf("CreateNIC failed: %s", err)
+	}
+	const addr = tcpip.Address("\x7f\x00\x00\x01")
+	if err := s.AddAddress(1, ipv4.ProtocolNumber, addr); err != nil {
+		t.Fatalf("AddAddress failed: %s", err)
+	}
+	s.SetRouteTable([]tcpip.Route{{Destination: header.IPv4EmptySubnet, NIC: 1}})
+	ns := &netstack.Stack{Stack: s}
+
+	// A dual-stack endpoint is registered for both IPv4 and IPv6, but its
+	// queued data is only counted once.
+	var rwq waiter.Queue
+	rcv, err := s.NewEndpoint(udp.ProtocolNumber, ipv6.ProtocolNumber, &rwq)
+	if err != nil {
+		t.Fatalf("NewEndpoint failed: %s", err)
+	}
+	defer rcv.Close()
+	if err := rcv.Bind(tcpip.FullAddress{Port: 1234}); err != nil {
+		t.Fatalf("Bind failed: %s", err)
+	}
+	if got := netstackQueued(ns); got != 0 {
+		t.Errorf("netstackQueued got %d before sending, want 0", got)
+	}
+
+	var swq waiter.Queue
+	snd, err := s.NewEndpoint(udp.ProtocolNumber, ipv4.ProtocolNumber, &swq)
+	if err != nil {
+		t.Fatalf("NewEndpoint failed: %s", err)
+	}
+	defer snd.Close()
+	payload := make([]byte, 100)
+	to := tcpip.FullAddress{Addr: addr, Port: 1234}
+	if _, err := snd.Write(bytes.NewReader(payload), tcpip.WriteOptions{To: &to}); err != nil {
+		t.Fatalf("Write failed: %s", err)
+	}
+	if got, want := netstackQueued(ns), uint64(len(payload)); got != want {
+		t.Errorf("netstackQueued got %d, want %d", got, want)
+	}
+}
+
+func TestMemoryBreakdownString(t *testing.T) {
+	mb := &MemoryBreakdown{
+		Anonymous:   3 << 10,
+		MemoryFile:  5 << 20,
+		GoHeapInuse: 2047,
+	}
+	got := mb.String()
+	for _, want := range []string{
+		"  Anonymous                       3 kB\n",
+		"  Total committed              5120 kB\n",
+		// Values are rounded down.
+		"  Heap in use                     1 kB\n",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("String got:\n%s\nwant a line %q", got, want)
+		}
+	}
+}
@@ -15,14 +15,20 @@
 package boot
 
 import (
+	"crypto/x509"
 	"fmt"
 	"net"
 	"runtime"
 	"strings"
 	"syscall"
+	"time"
 
+	"gvisor.dev/gvisor/pkg/abi/linux"
 	"gvisor.dev/gvisor/pkg/log"
+	"gvisor.dev/gvisor/pkg/sync"
 	"gvisor.dev/gvisor/pkg/tcpip"
+	"gvisor.dev/gvisor/pkg/tcpip/dnsstub"
+	"gvisor.dev/gvisor/pkg/tcpip/link/capture"
 	"gvisor.dev/gvisor/pkg/tcpip/link/fdbased"
 	"gvisor.dev/gvisor/pkg/tcpip/link/loopback"
 	"gvisor.dev/gvisor/pkg/tcpip/link/packetsocket"
@@ -64,6 +70,48 @@ var (
 // Network exposes methods that can be used to configure a network stack.
 type Network struct {
 	Stack *stack.Stack
+
+	// DNSStub is the configuration of the DNS stub resolver started once the
+	// links are created, or nil if it is disabled.
+	DNSStub *dnsstub.Config
+
+	// captureMu serializes packet captures.
+	captureMu sync.Mutex
+
+	// captureEndpoints are the endpoints of the NICs, through which packets
+	// are captured. It is set when links are created.
+	captureEndpoints []*capture.Endpoint
+}
+
+// NewDNSStubConfig returns the configuration of the DNS stub resolver set by
+// conf, or nil if it is disabled.
+func NewDNSStubConfig(conf *config.Config) (*dnsstub.Config, error) {
+	if !conf.DNSStub {
+		return nil, nil
+	}
+	upstreams, err := dnsstub.ParseUpstreams(conf.DNSUpstreams)
+	if err != nil {
+		return nil, err
+	}
+	forwards, err := dnsstub.ParseForwards(conf.DNSForward)
+	if err != nil {
+		return nil, err
+	}
+	c := &dnsstub.Config{
+		Upstreams: upstreams,
+		Forwards:  forwards,
+		CacheSize: conf.DNSCacheSize,
+	}
+	if c.UsesTLS() {
+		// The certificates are loaded now as files can't be opened once
+		// seccomp filters are installed.
+		roots, err := x509.SystemCertPool()
+		if err != nil {
+			return nil, fmt.Errorf("loading CA certificates for DNS-over-TLS: %v", err)
+		}
+		c.RootCAs = roots
+	}
+	return c, nil
 }
 
 // Route represents a route in the network stack.
@@ -91,9 +139,17 @@ type FDBasedLink struct {
 	LinkAddress        net.HardwareAddr
 	QDisc              config.QueueingDiscipline
 
+	// ResolutionDisabled disables link address resolution on the link,
+	// e.g. for ipvlan devices in L3 mode, which don't forward ARP.
+	ResolutionDisabled bool
+
 	// NumChannels controls how many underlying FD's are to be used to
 	// create this endpoint.
 	NumChannels int
+
+	// SaveRestore allows connections using the link to be saved and
+	// restored.
+	SaveRestore bool
 }
 
 // LoopbackLink configures a loopback li nk.
@@ -210,12 +266,14 @@ func (n *Network) CreateLinksAndRoutes(args *CreateLinksAndRoutesArgs, _ *struct
 			FDs:                FDs,
 			MTU:                uint32(link.MTU),
 			EthernetHeader:     true,
+			ResolutionDisabled: link.ResolutionDisabled,
 			Address:            mac,
 			PacketDispatchMode: fdbased.RecvMMsg,
 			GSOMaxSize:         link.GSOMaxSize,
 			SoftwareGSOEnabled: link.SoftwareGSOEnabled,
 			TXChecksumOffload:  link.TXChecksumOffload,
 			RXChecksumOffload:  link.RXChecksumOffload,
+			SaveRestore:        link.SaveRestore,
 		})
 		if err != nil {
 			return err
@@ -272,14 +330,102 @@ func (n *Network) CreateLinksAndRoutes(args *CreateLinksAndRoutesArgs, _ *struct
 
 	log.Infof("Setting routes %+v", routes)
 	n.Stack.SetRouteTable(routes)
+
+	if n.DNSStub != nil {
+		if len(args.LoopbackLinks) == 0 {
+			return fmt.Errorf("DNS stub resolver requires a loopback interface")
+		}
+		if err := n.startDNSStub(nicids[args.LoopbackLinks[0].Name]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// startDNSStub assigns the address of the DNS stub resolver to the loopback
+// NIC, and starts the resolver.
+func (n *Network) startDNSStub(nicID tcpip.NICID) error {
+	addr := tcpip.ProtocolAddress{
+		Protocol: ipv4.ProtocolNumber,
+		AddressWithPrefix: tcpip.AddressWithPrefix{
+			Address:   dnsstub.DefaultAddr,
+			PrefixLen: 8,
+		},
+	}
+	// The address is never used as the source of other connections.
+	if err := n.Stack.AddProtocolAddressWithOptions(nicID, addr, stack.NeverPrimaryEndpoint); err != nil && err != tcpip.ErrDuplicateAddress {
+		return fmt.Errorf("AddProtocolAddressWithOptions(%d, %+v, %d) failed: %v", nicID, addr, stack.NeverPrimaryEndpoint, err)
+	}
+
+	log.Infof("Starting DNS stub resolver on %s with upstreams %v and forwarding rules %+v", dnsstub.DefaultAddr, n.DNSStub.Upstreams, n.DNSStub.Forwards)
+	if _, err := dnsstub.New(n.Stack, *n.DNSStub); err != nil {
+		return fmt.Errorf("starting DNS stub resolver: %v", err)
+	}
 	return nil
 }
 
+// CaptureArgs are arguments to Capture.
+type CaptureArgs struct {
+	// FilePayload contains the files packets are captured to, in the pcapng
+	// format. The capture continues in the next file once one reaches
+	// FileSize, wrapping around to the first file.
+	urpc.FilePayload
+
+	// Filter is the classic BPF program selecting the captured packets. It
+	// runs on packets starting at their network header. All packets are
+	// captured if it is empty.
+	Filter []linux.BPFInstruction
+
+	// SnapLen is the maximum number of bytes captured from each packet, or
+	// zero for capture.DefaultSnapLen.
+	SnapLen uint32
+
+	// FileSize is the maximum size of each file, or zero for no limit.
+	FileSize int64
+
+	// Duration is the duration of the capture.
+	Duration time.Duration
+}
+
+// Capture captures the packets traversing all NICs for the duration of the
+// capture, and returns the number of packets captured.
+func (n *Network) Capture(args *CaptureArgs, packets *uint64) error {
+	defer func() {
+		for _, f := range args.FilePayload.Files {
+			f.Close()
+		}
+	}()
+
+	n.captureMu.Lock()
+	defer n.captureMu.Unlock()
+
+	files := make([]capture.File, 0, len(args.FilePayload.Files))
+	for _, f := range args.FilePayload.Files {
+		files = append(files, f)
+	}
+	c, err := capture.Start(n.captureEndpoints, capture.Options{
+		Filter:   args.Filter,
+		SnapLen:  args.SnapLen,
+		Files:    files,
+		FileSize: args.FileSize,
+	})
+	if err != nil {
+		return err
+	}
+	log.Infof("Capturing packets for %v", args.Duration)
+	time.Sleep(args.Duration)
+	*packets, err = c.Stop()
+	log.Infof("Captured %d packets", *packets)
+	return err
+}
+
 // createNICWithAddrs creates a NIC in the network stack and adds the given
 // addresses.
 func (n *Network) createNICWithAddrs(id tcpip.NICID, name string, ep stack.LinkEndpoint, addrs []IPWithPrefix) error {
+	captureEP := capture.New(ep, name)
+	n.captureEndpoints = append(n.captureEndpoints, captureEP)
 	opts := stack.NICOptions{Name: name}
-	if err := n.Stack.CreateNICWithOptions(id, sniffer.New(ep), opts); err != nil {
+	if err := n.Stack.CreateNICWithOptions(id, sniffer.New(captureEP), opts); err != nil {
 		return fmt.Errorf("CreateNICWithOptions(%d, _, %+v) failed: %v", id, opts, err)
 	}
 
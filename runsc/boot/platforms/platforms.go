@@ -13,6 +13,10 @@
 // limitations under the License.
 
 // Package platforms imports all available platform packages.
+//
+// Platforms maintained outside of this repository can't be added here; they
+// are instead imported by a separate main package that calls cli.Main. See
+// platform.Constructor.
 package platforms
 
 import (
@@ -0,0 +1,126 @@
+// Copyright 2020 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package boot
+
+import (
+	"fmt"
+	"path"
+
+	"gvisor.dev/gvisor/pkg/abi/linux"
+	"gvisor.dev/gvisor/pkg/context"
+	"gvisor.dev/gvisor/pkg/fspath"
+	"gvisor.dev/gvisor/pkg/log"
+	"gvisor.dev/gvisor/pkg/sentry/kernel"
+	"gvisor.dev/gvisor/pkg/sentry/kernel/auth"
+	"gvisor.dev/gvisor/pkg/sentry/vfs"
+	"gvisor.dev/gvisor/pkg/usermem"
+)
+
+// prefetchBufSize is the size of the buffer that prefetched files are read
+// into.
+const prefetchBufSize = 64 << 10
+
+// prefetch reads the files at the given absolute paths in the container's
+// mount namespace, recursing into directories, so that their contents are
+// cached by the time applications in the container read them. Files that
+// can't be read are skipped. It returns the number of files read.
+func (l *Loader) prefetch(cid string, paths []string) (int, error) {
+	if !kernel.VFS2Enabled {
+		return 0, fmt.Errorf("prefetching requires VFS2")
+	}
+	tg, err := l.threadGroupFromID(execID{cid: cid})
+	if err != nil {
+		return 0, err
+	}
+	// task.MountNamespaceVFS2() does not take a ref, so we must do so
+	// ourselves.
+	mns := tg.Leader().MountNamespaceVFS2()
+	if !mns.TryIncRef() {
+		return 0, fmt.Errorf("container %q has stopped", cid)
+	}
+	ctx := l.k.SupervisorContext()
+	defer mns.DecRef(ctx)
+
+	p := prefetcher{
+		ctx:    ctx,
+		creds:  auth.CredentialsFromContext(ctx),
+		vfsObj: l.k.VFS(),
+		root:   mns.Root(),
+		buf:    make([]byte, prefetchBufSize),
+	}
+	for _, name := range paths {
+		p.prefetch(path.Clean("/" + name))
+	}
+	return p.files, nil
+}
+
+// prefetcher holds the state of a call to Loader.prefetch.
+type prefetcher struct {
+	ctx    context.Context
+	creds  *auth.Credentials
+	vfsObj *vfs.VirtualFilesystem
+	root   vfs.VirtualDentry
+	buf    []byte
+
+	// files is the number of files read.
+	files int
+}
+
+func (p *prefetcher) prefetch(name string) {
+	fd, err := p.vfsObj.OpenAt(p.ctx, p.creds, &vfs.PathOperation{
+		Root:               p.root,
+		Start:              p.root,
+		Path:               fspath.Parse(name),
+		FollowFinalSymlink: true,
+	}, &vfs.OpenOptions{Flags: linux.O_RDONLY})
+	if err != nil {
+		log.Debugf("Not prefetching %q: %v", name, err)
+		return
+	}
+	defer fd.DecRef(p.ctx)
+	stat, err := fd.Stat(p.ctx, vfs.StatOptions{Mask: linux.STATX_TYPE})
+	if err != nil {
+		log.Debugf("Not prefetching %q: %v", name, err)
+		return
+	}
+
+	switch stat.Mode & linux.S_IFMT {
+	case linux.S_IFREG:
+		for off := int64(0); ; {
+			n, err := fd.PRead(p.ctx, usermem.BytesIOSequence(p.buf), off, vfs.ReadOptions{})
+			off += n
+			if n == 0 || err != nil {
+				break
+			}
+		}
+		p.files++
+
+	case linux.S_IFDIR:
+		// Symlinks in directories aren't followed, so that the same files
+		// aren't read repeatedly.
+		var children []string
+		if err := fd.IterDirents(p.ctx, vfs.IterDirentsCallbackFunc(func(dirent vfs.Dirent) error {
+			if dirent.Name != "." && dirent.Name != ".." && (dirent.Type == linux.DT_REG || dirent.Type == linux.DT_DIR) {
+				children = append(children, dirent.Name)
+			}
+			return nil
+		})); err != nil {
+			log.Debugf("Not prefetching all of %q: %v", name, err)
+		}
+		for _, child := range children {
+			p.prefetch(path.Join(name, child))
+		}
+	}
+}
@@ -15,8 +15,13 @@
 package boot
 
 import (
+	"bytes"
+	"fmt"
+	"math"
+	"strconv"
 	"strings"
 
+	"gvisor.dev/gvisor/pkg/log"
 	"gvisor.dev/gvisor/pkg/sentry/strace"
 	"gvisor.dev/gvisor/runsc/config"
 )
@@ -35,9 +40,97 @@ func enableStrace(conf *config.Config) error {
 	}
 	strace.LogMaximumSize = max
 
+	if conf.StraceFormat == "json" {
+		strace.SetLogSink(strace.NewJSONSink(logWriter{}))
+	}
+	if err := setStraceRateLimits(conf.StraceRateLimit); err != nil {
+		return err
+	}
+	if err := setStraceRedactions(conf.StraceRedact); err != nil {
+		return err
+	}
+
 	if len(conf.StraceSyscalls) == 0 {
 		strace.EnableAll(strace.SinkTypeLog)
 		return nil
 	}
 	return strace.Enable(strings.Split(conf.StraceSyscalls, ","), strace.SinkTypeLog)
 }
+
+// setContainerStrace traces the syscalls of the container with the given ID
+// as configured by conf, if it differs from rootConf, the configuration of the
+// sandbox.
+func setContainerStrace(cid string, rootConf, conf *config.Config) error {
+	if conf.Strace == rootConf.Strace && conf.StraceSyscalls == rootConf.StraceSyscalls {
+		return nil
+	}
+	var syscalls []string
+	if conf.StraceSyscalls != "" {
+		syscalls = strings.Split(conf.StraceSyscalls, ",")
+	}
+	return strace.SetContainerFilter(cid, conf.Strace, syscalls)
+}
+
+// setStraceRateLimits parses limits, a comma-separated list of
+// <syscall>:<rate> limits, and applies them.
+func setStraceRateLimits(limits string) error {
+	if limits == "" {
+		return nil
+	}
+	for _, limit := range strings.Split(limits, ",") {
+		parts := strings.Split(limit, ":")
+		if len(parts) != 2 {
+			return fmt.Errorf("invalid strace rate limit %q, must be <syscall>:<rate>", limit)
+		}
+		rate, err := strconv.ParseFloat(parts[1], 64)
+		if err != nil || rate <= 0 {
+			return fmt.Errorf("invalid strace rate limit %q, rate must be a positive number", limit)
+		}
+		// Allow bursts of up to a second's worth of syscalls.
+		burst := int(math.Ceil(rate))
+		if err := strace.SetRateLimit(parts[0], rate, burst); err != nil {
+			return fmt.Errorf("invalid strace rate limit %q: %v", limit, err)
+		}
+	}
+	return nil
+}
+
+// setStraceRedactions parses redactions, a comma-separated list of syscall
+// arguments to redact, and applies them.
+func setStraceRedactions(redactions string) error {
+	if redactions == "" {
+		return nil
+	}
+	for _, r := range strings.Split(redactions, ",") {
+		if r == "buffers" {
+			strace.RedactBuffers()
+			continue
+		}
+		parts := strings.Split(r, ":")
+		arg := -1
+		switch len(parts) {
+		case 1:
+		case 2:
+			var err error
+			arg, err = strconv.Atoi(parts[1])
+			if err != nil || arg < 0 {
+				return fmt.Errorf("invalid strace redaction %q, argument must be a non-negative index", r)
+			}
+		default:
+			return fmt.Errorf("invalid strace redaction %q, must be <syscall>[:<argument>] or buffers", r)
+		}
+		if err := strace.Redact(parts[0], arg); err != nil {
+			return fmt.Errorf("invalid strace redaction %q: %v", r, err)
+		}
+	}
+	return nil
+}
+
+// logWriter writes each line written to it to the sentry log.
+type logWriter struct{}
+
+// Write implements io.Writer.Write.
+func (logWriter) Write(b []byte) (int, error) {
+	log.Infof("%s", bytes.TrimSuffix(b, []byte("\n")))
+	return len(b), nil
+}
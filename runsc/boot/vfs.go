@@ -17,6 +17,7 @@ package boot
 import (
 	"fmt"
 	"sort"
+	"strconv"
 	"strings"
 
 	specs "github.com/opencontainers/runtime-spec/specs-go"
@@ -25,16 +26,24 @@ import (
 	"gvisor.dev/gvisor/pkg/context"
 	"gvisor.dev/gvisor/pkg/fspath"
 	"gvisor.dev/gvisor/pkg/log"
+	"gvisor.dev/gvisor/pkg/sentry/devices/attestdev"
+	"gvisor.dev/gvisor/pkg/sentry/devices/kfddev"
+	"gvisor.dev/gvisor/pkg/sentry/devices/loopdev"
 	"gvisor.dev/gvisor/pkg/sentry/devices/memdev"
 	"gvisor.dev/gvisor/pkg/sentry/devices/ttydev"
 	"gvisor.dev/gvisor/pkg/sentry/devices/tundev"
 	"gvisor.dev/gvisor/pkg/sentry/fs/user"
+	"gvisor.dev/gvisor/pkg/sentry/fsimpl/cgroupfs"
 	"gvisor.dev/gvisor/pkg/sentry/fsimpl/devpts"
 	"gvisor.dev/gvisor/pkg/sentry/fsimpl/devtmpfs"
+	"gvisor.dev/gvisor/pkg/sentry/fsimpl/erofs"
+	"gvisor.dev/gvisor/pkg/sentry/fsimpl/ext"
 	"gvisor.dev/gvisor/pkg/sentry/fsimpl/fuse"
 	"gvisor.dev/gvisor/pkg/sentry/fsimpl/gofer"
+	"gvisor.dev/gvisor/pkg/sentry/fsimpl/nfs"
 	"gvisor.dev/gvisor/pkg/sentry/fsimpl/overlay"
 	"gvisor.dev/gvisor/pkg/sentry/fsimpl/proc"
+	"gvisor.dev/gvisor/pkg/sentry/fsimpl/squashfs"
 	"gvisor.dev/gvisor/pkg/sentry/fsimpl/sys"
 	"gvisor.dev/gvisor/pkg/sentry/fsimpl/tmpfs"
 	"gvisor.dev/gvisor/pkg/sentry/inet"
@@ -43,9 +52,10 @@ import (
 	"gvisor.dev/gvisor/pkg/sentry/vfs"
 	"gvisor.dev/gvisor/pkg/syserror"
 	"gvisor.dev/gvisor/runsc/config"
+	"gvisor.dev/gvisor/runsc/specutils"
 )
 
-func registerFilesystems(k *kernel.Kernel) error {
+func registerFilesystems(k *kernel.Kernel, args *Args) error {
 	ctx := k.SupervisorContext()
 	creds := auth.NewRootCredentials(k.RootUserNamespace())
 	vfsObj := k.VFS()
@@ -83,27 +93,48 @@ func registerFilesystems(k *kernel.Kernel) error {
 		AllowUserMount: true,
 		AllowUserList:  true,
 	})
-
-	// Setup files in devtmpfs.
-	if err := memdev.Register(vfsObj); err != nil {
-		return fmt.Errorf("registering memdev: %w", err)
-	}
-	if err := ttydev.Register(vfsObj); err != nil {
-		return fmt.Errorf("registering ttydev: %w", err)
+	vfsObj.MustRegisterFilesystemType(fuse.VirtioFSName, &fuse.VirtioFSFilesystemType{}, &vfs.RegisterFilesystemTypeOptions{
+		AllowUserList: true,
+	})
+	vfsObj.MustRegisterFilesystemType(nfs.Name, &nfs.FilesystemType{}, &vfs.RegisterFilesystemTypeOptions{
+		AllowUserList: true,
+	})
+	vfsObj.MustRegisterFilesystemType(erofs.Name, &erofs.FilesystemType{}, &vfs.RegisterFilesystemTypeOptions{
+		AllowUserMount: true,
+		AllowUserList:  true,
+	})
+	vfsObj.MustRegisterFilesystemType(squashfs.Name, &squashfs.FilesystemType{}, &vfs.RegisterFilesystemTypeOptions{
+		AllowUserMount: true,
+		AllowUserList:  true,
+	})
+	// Filesystem images on block devices, such as loop devices, are mounted
+	// read-only by the ext implementation under any of the names used by
+	// Linux.
+	for _, name := range []string{"ext2", "ext3", "ext4"} {
+		vfsObj.MustRegisterFilesystemType(name, &ext.FilesystemType{}, &vfs.RegisterFilesystemTypeOptions{
+			AllowUserMount: true,
+			AllowUserList:  true,
+		})
 	}
-	tunSupported := tundev.IsNetTunSupported(inet.StackFromContext(ctx))
-	if tunSupported {
-		if err := tundev.Register(vfsObj); err != nil {
-			return fmt.Errorf("registering tundev: %v", err)
+
+	if args.Conf.Cgroupfs {
+		// The hierarchy is created before the first process, so that all
+		// processes are members of it.
+		cgroupfsType := &cgroupfs.FilesystemType{}
+		vfsObj.MustRegisterFilesystemType(cgroupfs.Name, cgroupfsType, &vfs.RegisterFilesystemTypeOptions{
+			AllowUserMount: true,
+			AllowUserList:  true,
+		})
+		if err := cgroupfsType.InitHierarchy(ctx, vfsObj, creds); err != nil {
+			return fmt.Errorf("creating cgroup hierarchy: %w", err)
 		}
 	}
 
-	if kernel.FUSEEnabled {
-		if err := fuse.Register(vfsObj); err != nil {
-			return fmt.Errorf("registering fusedev: %w", err)
-		}
+	if err := registerDevices(ctx, vfsObj, args); err != nil {
+		return err
 	}
 
+	// Setup files in devtmpfs.
 	a, err := devtmpfs.NewAccessor(ctx, vfsObj, creds, devtmpfs.Name)
 	if err != nil {
 		return fmt.Errorf("creating devtmpfs accessor: %w", err)
@@ -113,24 +144,45 @@ func registerFilesystems(k *kernel.Kernel) error {
 	if err := a.UserspaceInit(ctx); err != nil {
 		return fmt.Errorf("initializing userspace: %w", err)
 	}
-	if err := memdev.CreateDevtmpfsFiles(ctx, a); err != nil {
-		return fmt.Errorf("creating memdev devtmpfs files: %w", err)
+	if err := a.CreateRegisteredDeviceFiles(ctx); err != nil {
+		return fmt.Errorf("creating devtmpfs files: %w", err)
 	}
-	if err := ttydev.CreateDevtmpfsFiles(ctx, a); err != nil {
-		return fmt.Errorf("creating ttydev devtmpfs files: %w", err)
+	return nil
+}
+
+// registerDevices registers all devices supported by the sandbox in vfsObj.
+// Device special files are created in devtmpfs for them afterwards, so
+// optional devices are only visible if they are registered.
+func registerDevices(ctx context.Context, vfsObj *vfs.VirtualFilesystem, args *Args) error {
+	if err := memdev.Register(vfsObj); err != nil {
+		return fmt.Errorf("registering memdev: %w", err)
 	}
-	if tunSupported {
-		if err := tundev.CreateDevtmpfsFiles(ctx, a); err != nil {
-			return fmt.Errorf("creating tundev devtmpfs files: %v", err)
+	if err := ttydev.Register(vfsObj); err != nil {
+		return fmt.Errorf("registering ttydev: %w", err)
+	}
+	if err := loopdev.Register(vfsObj); err != nil {
+		return fmt.Errorf("registering loopdev: %w", err)
+	}
+	if tundev.IsNetTunSupported(inet.StackFromContext(ctx)) {
+		if err := tundev.Register(vfsObj); err != nil {
+			return fmt.Errorf("registering tundev: %v", err)
 		}
 	}
-
 	if kernel.FUSEEnabled {
-		if err := fuse.CreateDevtmpfsFile(ctx, a); err != nil {
-			return fmt.Errorf("creating fusedev devtmpfs files: %w", err)
+		if err := fuse.Register(vfsObj); err != nil {
+			return fmt.Errorf("registering fusedev: %w", err)
+		}
+	}
+	if args.AttestationDevice != "" {
+		if err := attestdev.Register(vfsObj, args.AttestationDevice, args.AttestationFD); err != nil {
+			return fmt.Errorf("registering attestdev: %w", err)
+		}
+	}
+	if args.KFDFD != 0 {
+		if err := kfddev.Register(vfsObj, args.KFDFD); err != nil {
+			return fmt.Errorf("registering kfddev: %w", err)
 		}
 	}
-
 	return nil
 }
 
@@ -196,38 +248,77 @@ func (c *containerMounter) mountAll(conf *config.Config, procArgs *kernel.Create
 // createMountNamespaceVFS2 creates the container's root mount and namespace.
 func (c *containerMounter) createMountNamespaceVFS2(ctx context.Context, conf *config.Config, creds *auth.Credentials) (*vfs.MountNamespace, error) {
 	fd := c.fds.remove()
-	data := p9MountData(fd, conf.FileAccess, true /* vfs2 */)
+	fsName := gofer.Name
+	var opts *vfs.MountOptions
+	if c.rootfsType != "" {
+		log.Infof("Mounting root from %s image, imageFD: %d", c.rootfsType, fd)
+		// The image itself is immutable; writes only succeed if an overlay
+		// is added below.
+		opts = &vfs.MountOptions{
+			ReadOnly: c.root.Readonly,
+			GetFilesystemOptions: vfs.GetFilesystemOptions{
+				Data: "fd=" + strconv.Itoa(fd),
+			},
+			InternalMount: true,
+		}
+		fsName = c.rootfsType
+	} else {
+		data := p9MountData(fd, conf.FileAccess, true /* vfs2 */, conf)
+
+		if conf.OverlayfsStaleRead {
+			// We can't check for overlayfs here because sandbox is chroot'ed and gofer
+			// can only send mount options for specs.Mounts (specs.Root is missing
+			// Options field). So assume root is always on top of overlayfs.
+			data = append(data, "overlayfs_stale_read")
+		}
+		data = append(data, c.rootIDMap...)
 
-	if conf.OverlayfsStaleRead {
-		// We can't check for overlayfs here because sandbox is chroot'ed and gofer
-		// can only send mount options for specs.Mounts (specs.Root is missing
-		// Options field). So assume root is always on top of overlayfs.
-		data = append(data, "overlayfs_stale_read")
-	}
+		iopts := gofer.InternalFilesystemOptions{
+			UniqueID: "/",
+		}
+		if c.root.Readonly || conf.Overlay {
+			// The sandbox never writes to the gofer mount.
+			iopts.ReadCache = c.goferReadCache
+		}
 
-	log.Infof("Mounting root over 9P, ioFD: %d", fd)
-	opts := &vfs.MountOptions{
-		ReadOnly: c.root.Readonly,
-		GetFilesystemOptions: vfs.GetFilesystemOptions{
-			Data: strings.Join(data, ","),
-			InternalData: gofer.InternalFilesystemOptions{
-				UniqueID: "/",
+		log.Infof("Mounting root over 9P, ioFD: %d", fd)
+		opts = &vfs.MountOptions{
+			ReadOnly: c.root.Readonly,
+			GetFilesystemOptions: vfs.GetFilesystemOptions{
+				Data:         strings.Join(data, ","),
+				InternalData: iopts,
 			},
-		},
-		InternalMount: true,
+			InternalMount: true,
+		}
 	}
 
-	fsName := gofer.Name
 	if conf.Overlay && !c.root.Readonly {
 		log.Infof("Adding overlay on top of root")
 		var err error
 		var cleanup func()
-		opts, cleanup, err = c.configureOverlay(ctx, creds, opts, fsName)
+		var upperData string
+		if c.overlayUpper != nil && c.k.SpillMemoryFile() != nil {
+			// The persisted upper layer can be as large as the host file
+			// that holds it, so keep its file data in host storage
+			// rather than memory.
+			upperData = "spill_threshold=0"
+		}
+		opts, cleanup, err = c.configureOverlay(ctx, creds, opts, fsName, upperData)
 		if err != nil {
 			return nil, fmt.Errorf("mounting root with overlay: %w", err)
 		}
 		defer cleanup()
 		fsName = overlay.Name
+
+		if c.overlayUpper != nil {
+			// Restore the persisted upper layer before the overlay is
+			// mounted over it.
+			log.Infof("Loading overlay upper layer of root")
+			upperRoot := opts.GetFilesystemOptions.InternalData.(overlay.FilesystemOptions).UpperRoot
+			if err := c.overlayUpper.Load(ctx, creds, upperRoot); err != nil {
+				return nil, fmt.Errorf("loading root overlay upper layer: %w", err)
+			}
+		}
 	}
 
 	mns, err := c.k.VFS().NewMountNamespace(ctx, creds, "", fsName, opts)
@@ -238,10 +329,11 @@ func (c *containerMounter) createMountNamespaceVFS2(ctx context.Context, conf *c
 }
 
 // configureOverlay mounts the lower layer using "lowerOpts", mounts the upper
-// layer using tmpfs, and return overlay mount options. "cleanup" must be called
+// layer using tmpfs with the mount options in "upperData", and return overlay
+// mount options. "cleanup" must be called
 // after the options have been used to mount the overlay, to release refs on
 // lower and upper mounts.
-func (c *containerMounter) configureOverlay(ctx context.Context, creds *auth.Credentials, lowerOpts *vfs.MountOptions, lowerFSName string) (*vfs.MountOptions, func(), error) {
+func (c *containerMounter) configureOverlay(ctx context.Context, creds *auth.Credentials, lowerOpts *vfs.MountOptions, lowerFSName, upperData string) (*vfs.MountOptions, func(), error) {
 	// First copy options from lower layer to upper layer and overlay. Clear
 	// filesystem specific options.
 	upperOpts := *lowerOpts
@@ -279,6 +371,7 @@ func (c *containerMounter) configureOverlay(ctx context.Context, creds *auth.Cre
 	}
 
 	// Upper is a tmpfs mount to keep all modifications inside the sandbox.
+	upperOpts.GetFilesystemOptions.Data = upperData
 	upperOpts.GetFilesystemOptions.InternalData = tmpfs.FilesystemOpts{
 		RootFileType: uint16(rootType),
 	}
@@ -383,6 +476,14 @@ func (c *containerMounter) mountSubmountsVFS2(ctx context.Context, conf *config.
 		}
 	}
 
+	// /dev/shm is mounted after all submounts, including /dev, since
+	// containers' own /dev/shm mounts are ignored.
+	if hint := c.hints.shm; hint != nil {
+		if _, err := c.mountSharedSubmountVFS2(ctx, conf, mns, creds, hint.mount, hint); err != nil {
+			return fmt.Errorf("mount shared /dev/shm: %w", err)
+		}
+	}
+
 	if err := c.mountTmpVFS2(ctx, conf, creds, mns); err != nil {
 		return fmt.Errorf(`mount submount "\tmp": %w`, err)
 	}
@@ -401,9 +502,9 @@ func (c *containerMounter) prepareMountsVFS2() ([]mountAndFD, error) {
 	var mounts []mountAndFD
 	for _, m := range c.mounts {
 		fd := -1
-		// Only bind mounts use host FDs; see
-		// containerMounter.getMountNameAndOptionsVFS2.
-		if m.Type == bind {
+		// Only bind, virtiofs, NFS, EROFS and SquashFS mounts use host FDs;
+		// see containerMounter.getMountNameAndOptionsVFS2.
+		if m.Type == bind || m.Type == fuse.VirtioFSName || specutils.IsNFSMount(m) || specutils.IsImageMount(m) {
 			fd = c.fds.remove()
 		}
 		mounts = append(mounts, mountAndFD{
@@ -440,7 +541,7 @@ func (c *containerMounter) mountSubmountVFS2(ctx context.Context, conf *config.C
 	if useOverlay {
 		log.Infof("Adding overlay on top of mount %q", submount.Destination)
 		var cleanup func()
-		opts, cleanup, err = c.configureOverlay(ctx, creds, opts, fsName)
+		opts, cleanup, err = c.configureOverlay(ctx, creds, opts, fsName, "" /* upperData */)
 		if err != nil {
 			return nil, fmt.Errorf("mounting volume with overlay at %q: %w", submount.Destination, err)
 		}
@@ -482,7 +583,7 @@ func (c *containerMounter) getMountNameAndOptionsVFS2(conf *config.Config, m *mo
 
 	case tmpfs.Name:
 		var err error
-		data, err = parseAndFilterOptions(m.Options, tmpfsAllowedData...)
+		data, err = parseAndFilterOptions(m.Options, tmpfsVFS2AllowedData...)
 		if err != nil {
 			return "", nil, false, err
 		}
@@ -494,13 +595,56 @@ func (c *containerMounter) getMountNameAndOptionsVFS2(conf *config.Config, m *mo
 			// but unlikely to be correct in this context.
 			return "", nil, false, fmt.Errorf("9P mount requires a connection FD")
 		}
-		data = p9MountData(m.fd, c.getMountAccessType(m.Mount), true /* vfs2 */)
-		iopts = gofer.InternalFilesystemOptions{
-			UniqueID: m.Destination,
+		data = p9MountData(m.fd, c.getMountAccessType(m.Mount), true /* vfs2 */, conf)
+		idMap, err := parseAndFilterOptions(m.Options, idMapOptions...)
+		if err != nil {
+			return "", nil, false, err
 		}
+		data = append(data, idMap...)
 
 		// If configured, add overlay to all writable mounts.
-		useOverlay = conf.Overlay && !mountFlags(m.Options).ReadOnly
+		readOnly := mountFlags(m.Options).ReadOnly
+		useOverlay = conf.Overlay && !readOnly
+
+		goferOpts := gofer.InternalFilesystemOptions{
+			UniqueID: m.Destination,
+		}
+		if readOnly || useOverlay {
+			// The sandbox never writes to the gofer mount.
+			goferOpts.ReadCache = c.goferReadCache
+		}
+		iopts = goferOpts
+
+	case fuse.VirtioFSName:
+		if m.fd == 0 {
+			return "", nil, false, fmt.Errorf("virtiofs mount requires a vhost-user connection FD")
+		}
+		data = []string{"fd=" + strconv.Itoa(m.fd)}
+
+	case nfs.Name:
+		if m.fd == 0 {
+			return "", nil, false, fmt.Errorf("NFS mount requires a server connection FD")
+		}
+		_, export := specutils.SplitNFSSource(m.Source)
+		if strings.Contains(export, ",") {
+			return "", nil, false, fmt.Errorf("NFS export path %q must not contain ','", export)
+		}
+		data = []string{"fd=" + strconv.Itoa(m.fd), "export=" + export}
+
+	case erofs.Name, squashfs.Name:
+		if m.fd == 0 {
+			return "", nil, false, fmt.Errorf("%s mount requires an image FD", m.Type)
+		}
+		data = []string{"fd=" + strconv.Itoa(m.fd)}
+
+	case cgroupfs.Name, cgroupV1:
+		if !conf.Cgroupfs {
+			log.Warningf("ignoring %q mount, use --cgroupfs to emulate cgroups", m.Type)
+			return "", nil, false, nil
+		}
+		// cgroup v1 mounts are served by the emulated cgroup v2 hierarchy,
+		// which container runtimes detect with statfs(2).
+		fsName = cgroupfs.Name
 
 	default:
 		log.Warningf("ignoring unknown filesystem type %q", m.Type)
@@ -625,6 +769,14 @@ func (c *containerMounter) processHintsVFS2(conf *config.Config, creds *auth.Cre
 		}
 		hint.vfsMount = mnt
 	}
+	if hint := c.hints.shm; hint != nil {
+		log.Infof("Mounting master of shared /dev/shm, options: %s", hint.mount.Options)
+		mnt, err := c.mountSharedMasterVFS2(ctx, conf, hint, creds)
+		if err != nil {
+			return fmt.Errorf("mounting shared /dev/shm: %v", err)
+		}
+		hint.vfsMount = mnt
+	}
 	return nil
 }
 
@@ -645,7 +797,7 @@ func (c *containerMounter) mountSharedMasterVFS2(ctx context.Context, conf *conf
 	if useOverlay {
 		log.Infof("Adding overlay on top of shared mount %q", mntFD.Destination)
 		var cleanup func()
-		opts, cleanup, err = c.configureOverlay(ctx, creds, opts, fsName)
+		opts, cleanup, err = c.configureOverlay(ctx, creds, opts, fsName, "" /* upperData */)
 		if err != nil {
 			return nil, fmt.Errorf("mounting shared volume with overlay at %q: %w", mntFD.Destination, err)
 		}
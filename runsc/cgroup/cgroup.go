@@ -231,18 +231,58 @@ func loadPathsHelper(cgroup io.Reader) (map[string]string, error) {
 // Cgroup represents a group inside all controllers. For example:
 //   Name='/foo/bar' maps to /sys/fs/cgroup/<controller>/foo/bar on
 //   all controllers.
+//
+// On hosts that only have the cgroup v2 unified hierarchy, V2 is set and the
+// group is at Path in the unified hierarchy.
 type Cgroup struct {
 	Name    string            `json:"name"`
 	Parents map[string]string `json:"parents"`
 	Own     map[string]bool   `json:"own"`
+
+	// V2 is set if the group is in the cgroup v2 unified hierarchy.
+	V2 bool `json:"v2,omitempty"`
+
+	// Path is the path of the group in the unified hierarchy.
+	Path string `json:"path,omitempty"`
+
+	// Unit is the systemd scope unit that holds the group, if it's managed by
+	// systemd.
+	Unit string `json:"unit,omitempty"`
+
+	// Slice is the systemd slice of Unit.
+	Slice string `json:"slice,omitempty"`
+
+	// CPUSetPartition is the cpuset partition type of the group, one of
+	// "member", "root" or "isolated".
+	CPUSetPartition string `json:"cpusetPartition,omitempty"`
+}
+
+// Options are options for creating a Cgroup.
+type Options struct {
+	// Systemd creates the group through systemd, in which case the cgroups
+	// path has the form "slice:prefix:name". Only supported with cgroup v2.
+	Systemd bool
+
+	// CPUSetPartition makes the group a cpuset partition of this type. Only
+	// supported with cgroup v2.
+	CPUSetPartition string
 }
 
 // New creates a new Cgroup instance if the spec includes a cgroup path.
 // Returns nil otherwise.
-func New(spec *specs.Spec) (*Cgroup, error) {
+func New(spec *specs.Spec, opts Options) (*Cgroup, error) {
 	if spec.Linux == nil || spec.Linux.CgroupsPath == "" {
 		return nil, nil
 	}
+	if IsOnlyV2() {
+		return newV2(spec.Linux.CgroupsPath, opts)
+	}
+	if opts.Systemd {
+		return nil, fmt.Errorf("the systemd cgroup driver requires cgroup v2")
+	}
+	if opts.CPUSetPartition != "" {
+		return nil, fmt.Errorf("cpuset partitions require cgroup v2")
+	}
 	var parents map[string]string
 	if !filepath.IsAbs(spec.Linux.CgroupsPath) {
 		var err error
@@ -263,6 +303,9 @@ func New(spec *specs.Spec) (*Cgroup, error) {
 // already exists, it means that the caller has already provided a
 // pre-configured cgroups, and 'res' is ignored.
 func (c *Cgroup) Install(res *specs.LinuxResources) error {
+	if c.V2 {
+		return c.installV2(res)
+	}
 	log.Debugf("Creating cgroup %q", c.Name)
 
 	// The Cleanup object cleans up partially created cgroups when an error occurs.
@@ -297,9 +340,31 @@ func (c *Cgroup) Install(res *specs.LinuxResources) error {
 	return nil
 }
 
+// Update changes the configuration of the cgroups created by Install according
+// to 'res'. Pre-configured cgroups provided by the caller are left unchanged.
+func (c *Cgroup) Update(res *specs.LinuxResources) error {
+	if c.V2 {
+		return c.updateV2(res)
+	}
+	for key, cfg := range controllers {
+		if !c.Own[key] {
+			continue
+		}
+		path := c.makePath(key)
+		log.Debugf("Updating cgroup %q", path)
+		if err := cfg.ctrlr.set(res, path); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 // Uninstall removes the settings done in Install(). If cgroup path already
 // existed when Install() was called, Uninstall is a noop.
 func (c *Cgroup) Uninstall() error {
+	if c.V2 {
+		return c.uninstallV2()
+	}
 	log.Debugf("Deleting cgroup %q", c.Name)
 	for key := range controllers {
 		if !c.Own[key] {
@@ -332,6 +397,9 @@ func (c *Cgroup) Uninstall() error {
 // Join adds the current process to the all controllers. Returns function that
 // restores cgroup to the original state.
 func (c *Cgroup) Join() (func(), error) {
+	if c.V2 {
+		return c.joinV2()
+	}
 	// First save the current state so it can be restored.
 	undo := func() {}
 	paths, err := LoadPaths("self")
@@ -373,6 +441,9 @@ func (c *Cgroup) Join() (func(), error) {
 
 // CPUQuota returns the CFS CPU quota.
 func (c *Cgroup) CPUQuota() (float64, error) {
+	if c.V2 {
+		return c.cpuQuotaV2()
+	}
 	path := c.makePath("cpu")
 	quota, err := getInt(path, "cpu.cfs_quota_us")
 	if err != nil {
@@ -390,6 +461,9 @@ func (c *Cgroup) CPUQuota() (float64, error) {
 
 // NumCPU returns the number of CPUs configured in 'cpuset/cpuset.cpus'.
 func (c *Cgroup) NumCPU() (int, error) {
+	if c.V2 {
+		return c.numCPUV2()
+	}
 	path := c.makePath("cpuset")
 	cpuset, err := getValue(path, "cpuset.cpus")
 	if err != nil {
@@ -400,6 +474,9 @@ func (c *Cgroup) NumCPU() (int, error) {
 
 // MemoryLimit returns the memory limit.
 func (c *Cgroup) MemoryLimit() (uint64, error) {
+	if c.V2 {
+		return c.memoryLimitV2()
+	}
 	path := c.makePath("memory")
 	limStr, err := getValue(path, "memory.limit_in_bytes")
 	if err != nil {
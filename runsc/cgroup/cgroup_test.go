@@ -730,3 +730,319 @@ func TestLoadPaths(t *testing.T) {
 		})
 	}
 }
+
+func TestCPU2(t *testing.T) {
+	for _, tc := range []struct {
+		name  string
+		spec  *specs.LinuxCPU
+		wants map[string]string
+	}{
+		{
+			name: "all",
+			spec: &specs.LinuxCPU{
+				Shares: uint64Ptr(1024),
+				Quota:  int64Ptr(50000),
+				Period: uint64Ptr(200000),
+			},
+			wants: map[string]string{
+				"cpu.weight": "39",
+				"cpu.max":    "50000 200000",
+			},
+		},
+		{
+			name: "default_period",
+			spec: &specs.LinuxCPU{
+				Quota: int64Ptr(50000),
+			},
+			wants: map[string]string{
+				"cpu.max": "50000 100000",
+			},
+		},
+		{
+			name: "unlimited_quota",
+			spec: &specs.LinuxCPU{
+				Quota:  int64Ptr(-1),
+				Period: uint64Ptr(100000),
+			},
+			wants: map[string]string{
+				"cpu.max": "max 100000",
+			},
+		},
+		{
+			name: "nil_values",
+			spec: &specs.LinuxCPU{},
+		},
+		{
+			name: "nil",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			dir, err := ioutil.TempDir(testutil.TmpDir(), "cgroup")
+			if err != nil {
+				t.Fatalf("error creating temporary directory: %v", err)
+			}
+			defer os.RemoveAll(dir)
+
+			spec := &specs.LinuxResources{
+				CPU: tc.spec,
+			}
+			ctrlr := cpu2{}
+			if err := ctrlr.set(spec, dir); err != nil {
+				t.Fatalf("ctrlr.set(): %v", err)
+			}
+			checkDir(t, dir, tc.wants)
+		})
+	}
+}
+
+func TestIO2(t *testing.T) {
+	for _, tc := range []struct {
+		name  string
+		spec  *specs.LinuxBlockIO
+		wants map[string]string
+	}{
+		{
+			name: "weight",
+			spec: &specs.LinuxBlockIO{
+				Weight: uint16Ptr(500),
+			},
+			wants: map[string]string{
+				"io.weight": "default 4950",
+			},
+		},
+		{
+			name: "weight_device",
+			spec: &specs.LinuxBlockIO{
+				WeightDevice: []specs.LinuxWeightDevice{
+					makeLinuxWeightDevice(8, 0, uint16Ptr(1000), nil),
+				},
+			},
+			wants: map[string]string{
+				"io.weight": "8:0 10000",
+			},
+		},
+		{
+			name: "throttle",
+			spec: &specs.LinuxBlockIO{
+				ThrottleWriteIOPSDevice: []specs.LinuxThrottleDevice{
+					makeLinuxThrottleDevice(8, 16, 100),
+				},
+			},
+			wants: map[string]string{
+				"io.max": "8:16 wiops=100",
+			},
+		},
+		{
+			name: "nil_values",
+			spec: &specs.LinuxBlockIO{},
+		},
+		{
+			name: "nil",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			dir, err := ioutil.TempDir(testutil.TmpDir(), "cgroup")
+			if err != nil {
+				t.Fatalf("error creating temporary directory: %v", err)
+			}
+			defer os.RemoveAll(dir)
+
+			spec := &specs.LinuxResources{
+				BlockIO: tc.spec,
+			}
+			ctrlr := io2{}
+			if err := ctrlr.set(spec, dir); err != nil {
+				t.Fatalf("ctrlr.set(): %v", err)
+			}
+			checkDir(t, dir, tc.wants)
+		})
+	}
+}
+
+func TestMemory2(t *testing.T) {
+	for _, tc := range []struct {
+		name  string
+		spec  *specs.LinuxMemory
+		wants map[string]string
+		err   string
+	}{
+		{
+			name: "all",
+			spec: &specs.LinuxMemory{
+				Limit:       int64Ptr(1000),
+				Reservation: int64Ptr(500),
+				Swap:        int64Ptr(3000),
+			},
+			wants: map[string]string{
+				"memory.max":      "1000",
+				"memory.low":      "500",
+				"memory.swap.max": "2000",
+			},
+		},
+		{
+			name: "unlimited",
+			spec: &specs.LinuxMemory{
+				Limit: int64Ptr(-1),
+				Swap:  int64Ptr(-1),
+			},
+			wants: map[string]string{
+				"memory.max":      "max",
+				"memory.swap.max": "max",
+			},
+		},
+		{
+			name: "no_swap",
+			spec: &specs.LinuxMemory{
+				Limit: int64Ptr(1000),
+				Swap:  int64Ptr(1000),
+			},
+			wants: map[string]string{
+				"memory.max":      "1000",
+				"memory.swap.max": "0",
+			},
+		},
+		{
+			name: "swap_below_limit",
+			spec: &specs.LinuxMemory{
+				Limit: int64Ptr(1000),
+				Swap:  int64Ptr(500),
+			},
+			err: "must be greater than the memory limit",
+		},
+		{
+			name: "nil_values",
+			spec: &specs.LinuxMemory{},
+		},
+		{
+			name: "nil",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			dir, err := ioutil.TempDir(testutil.TmpDir(), "cgroup")
+			if err != nil {
+				t.Fatalf("error creating temporary directory: %v", err)
+			}
+			defer os.RemoveAll(dir)
+
+			spec := &specs.LinuxResources{
+				Memory: tc.spec,
+			}
+			ctrlr := memory2{}
+			err = ctrlr.set(spec, dir)
+			if len(tc.err) != 0 {
+				if err == nil || !strings.Contains(err.Error(), tc.err) {
+					t.Fatalf("ctrlr.set() wrong error, want: *%s*, got: %v", tc.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ctrlr.set(): %v", err)
+			}
+			checkDir(t, dir, tc.wants)
+		})
+	}
+}
+
+func TestLoadUnifiedPath(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		cgroups string
+		want    string
+		err     string
+	}{
+		{
+			name:    "unified",
+			cgroups: "0::/user.slice/session.scope\n",
+			want:    "/user.slice/session.scope",
+		},
+		{
+			name: "hybrid",
+			cgroups: "1:cpu:/path\n" +
+				"0::/unified\n",
+			want: "/unified",
+		},
+		{
+			name:    "v1",
+			cgroups: "1:cpu:/path\n",
+			err:     "no cgroup v2 found",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := loadUnifiedPathHelper(strings.NewReader(tc.cgroups))
+			if len(tc.err) != 0 {
+				if err == nil || !strings.Contains(err.Error(), tc.err) {
+					t.Fatalf("Wrong error message, want: *%s*, got: %v", tc.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if got != tc.want {
+				t.Errorf("Wrong path, want: %q, got: %q", tc.want, got)
+			}
+		})
+	}
+}
+
+func TestExpandSlice(t *testing.T) {
+	for _, tc := range []struct {
+		slice string
+		want  string
+		err   bool
+	}{
+		{slice: "-.slice", want: "/"},
+		{slice: "system.slice", want: "/system.slice"},
+		{slice: "a-b-c.slice", want: "/a.slice/a-b.slice/a-b-c.slice"},
+		{slice: "a--b.slice", err: true},
+		{slice: "a/b.slice", err: true},
+		{slice: "system.scope", err: true},
+	} {
+		t.Run(tc.slice, func(t *testing.T) {
+			got, err := expandSlice(tc.slice)
+			if tc.err {
+				if err == nil {
+					t.Fatalf("expandSlice(%q) succeeded, want error", tc.slice)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("expandSlice(%q): %v", tc.slice, err)
+			}
+			if got != tc.want {
+				t.Errorf("expandSlice(%q) = %q, want: %q", tc.slice, got, tc.want)
+			}
+		})
+	}
+}
+
+func TestParseSystemdPath(t *testing.T) {
+	for _, tc := range []struct {
+		path  string
+		slice string
+		unit  string
+		err   bool
+	}{
+		{path: "system.slice:runsc:foo", slice: "system.slice", unit: "runsc-foo.scope"},
+		{path: ":runsc:foo", slice: "system.slice", unit: "runsc-foo.scope"},
+		{path: "user.slice::foo", slice: "user.slice", unit: "foo.scope"},
+		{path: "system.slice:runsc:", err: true},
+		{path: "/foo", err: true},
+	} {
+		t.Run(tc.path, func(t *testing.T) {
+			slice, unit, err := parseSystemdPath(tc.path)
+			if tc.err {
+				if err == nil {
+					t.Fatalf("parseSystemdPath(%q) succeeded, want error", tc.path)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parseSystemdPath(%q): %v", tc.path, err)
+			}
+			if slice != tc.slice || unit != tc.unit {
+				t.Errorf("parseSystemdPath(%q) = %q, %q, want: %q, %q", tc.path, slice, unit, tc.slice, tc.unit)
+			}
+		})
+	}
+}
@@ -0,0 +1,516 @@
+// Copyright 2020 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cgroup
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/cenkalti/backoff"
+	specs "github.com/opencontainers/runtime-spec/specs-go"
+	"golang.org/x/sys/unix"
+	"gvisor.dev/gvisor/pkg/cleanup"
+	"gvisor.dev/gvisor/pkg/log"
+)
+
+// unifiedKey is the key of the unified hierarchy in Cgroup.Own.
+const unifiedKey = ""
+
+// controllers2 are the cgroup v2 controllers configured by runsc. Controllers
+// that the host doesn't provide are skipped.
+var controllers2 = map[string]controller{
+	"cpu":     &cpu2{},
+	"cpuset":  &cpuSet2{},
+	"hugetlb": &hugeTLB2{},
+	"io":      &io2{},
+	"memory":  &memory2{},
+	"pids":    &pids{},
+}
+
+// IsOnlyV2 returns true if cgroups are only available through the cgroup v2
+// unified hierarchy, as opposed to the v1 or hybrid hierarchies.
+func IsOnlyV2() bool {
+	var stat unix.Statfs_t
+	if err := unix.Statfs(cgroupRoot, &stat); err != nil {
+		return false
+	}
+	return stat.Type == unix.CGROUP2_SUPER_MAGIC
+}
+
+// newV2 returns a Cgroup in the unified hierarchy for the given cgroups path.
+func newV2(cgroupsPath string, opts Options) (*Cgroup, error) {
+	c := &Cgroup{
+		Name:            cgroupsPath,
+		Own:             make(map[string]bool),
+		V2:              true,
+		CPUSetPartition: opts.CPUSetPartition,
+	}
+	if opts.Systemd {
+		slice, unit, err := parseSystemdPath(cgroupsPath)
+		if err != nil {
+			return nil, err
+		}
+		dir, err := expandSlice(slice)
+		if err != nil {
+			return nil, err
+		}
+		c.Unit = unit
+		c.Slice = slice
+		c.Path = filepath.Join(dir, unit)
+		return c, nil
+	}
+	if filepath.IsAbs(cgroupsPath) {
+		c.Path = cgroupsPath
+		return c, nil
+	}
+	parent, err := loadUnifiedPath("self")
+	if err != nil {
+		return nil, fmt.Errorf("finding current cgroup: %w", err)
+	}
+	c.Path = filepath.Join(parent, cgroupsPath)
+	return c, nil
+}
+
+// loadUnifiedPath returns the path of the cgroup v2 of 'pid', which may be
+// set to 'self'.
+func loadUnifiedPath(pid string) (string, error) {
+	f, err := os.Open(filepath.Join("/proc", pid, "cgroup"))
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	return loadUnifiedPathHelper(f)
+}
+
+func loadUnifiedPathHelper(cgroup io.Reader) (string, error) {
+	scanner := bufio.NewScanner(cgroup)
+	for scanner.Scan() {
+		// The unified hierarchy has ID 0 and no controllers: "0::/path".
+		if path := strings.TrimPrefix(scanner.Text(), "0::"); path != scanner.Text() {
+			return path, nil
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return "", err
+	}
+	return "", fmt.Errorf("no cgroup v2 found")
+}
+
+// unifiedPath returns the path of the cgroup in the file system.
+func (c *Cgroup) unifiedPath() string {
+	return filepath.Join(cgroupRoot, c.Path)
+}
+
+// availableControllers returns the controllers that can be configured in the
+// cgroup at path.
+func availableControllers(path string) (map[string]bool, error) {
+	data, err := getValue(path, "cgroup.controllers")
+	if err != nil {
+		return nil, err
+	}
+	available := make(map[string]bool)
+	for _, name := range strings.Fields(data) {
+		available[name] = true
+	}
+	return available, nil
+}
+
+// enableControllers enables the controllers of controllers2 in the ancestors
+// of the cgroup at path, so that they can be configured in the cgroup.
+// Controllers that can't be enabled are skipped, e.g. because an ancestor has
+// processes of its own.
+func enableControllers(path string) {
+	rel, err := filepath.Rel(cgroupRoot, filepath.Dir(path))
+	if err != nil {
+		return
+	}
+	dir := cgroupRoot
+	for _, elem := range append([]string{""}, strings.Split(rel, "/")...) {
+		dir = filepath.Join(dir, elem)
+		if elem == "." {
+			continue
+		}
+		available, err := availableControllers(dir)
+		if err != nil {
+			log.Warningf("Reading available cgroup controllers of %q: %v", dir, err)
+			return
+		}
+		for name := range controllers2 {
+			if !available[name] {
+				continue
+			}
+			if err := setValue(dir, "cgroup.subtree_control", "+"+name); err != nil {
+				log.Infof("Enabling cgroup controller %q in %q: %v", name, dir, err)
+			}
+		}
+	}
+}
+
+func (c *Cgroup) installV2(res *specs.LinuxResources) error {
+	path := c.unifiedPath()
+	log.Debugf("Creating cgroup %q", path)
+
+	clean := cleanup.Make(func() { _ = c.Uninstall() })
+	defer clean.Clean()
+
+	if c.Unit != "" {
+		created, err := startSystemdScope(c.Unit, c.Slice, os.Getpid())
+		if err != nil {
+			return err
+		}
+		if !created {
+			log.Debugf("Using pre-created systemd unit %q", c.Unit)
+			clean.Release()
+			return nil
+		}
+		c.Own[unifiedKey] = true
+		if err := waitForCgroup(path); err != nil {
+			return err
+		}
+	} else {
+		if _, err := os.Stat(path); err == nil {
+			// If cgroup has already been created; it has been setup by caller. Don't
+			// make any changes to configuration, just join when sandbox/gofer starts.
+			log.Debugf("Using pre-created cgroup %q", path)
+			clean.Release()
+			return nil
+		}
+		enableControllers(path)
+		c.Own[unifiedKey] = true
+		if err := os.MkdirAll(path, 0755); err != nil {
+			return err
+		}
+	}
+
+	if err := c.setV2(res); err != nil {
+		return err
+	}
+	if c.CPUSetPartition != "" {
+		if err := setValue(path, "cpuset.cpus.partition", c.CPUSetPartition); err != nil {
+			return fmt.Errorf("setting cpuset partition to %q: %w", c.CPUSetPartition, err)
+		}
+		if p, err := getValue(path, "cpuset.cpus.partition"); err == nil && strings.Contains(p, "invalid") {
+			return fmt.Errorf("cpuset partition %q is invalid: %s; cpuset.cpus must be set to CPUs that are exclusive to the sandbox", c.CPUSetPartition, strings.TrimSpace(p))
+		}
+	}
+	clean.Release()
+	return nil
+}
+
+// setV2 configures the available controllers of the cgroup according to 'res'.
+func (c *Cgroup) setV2(res *specs.LinuxResources) error {
+	path := c.unifiedPath()
+	available, err := availableControllers(path)
+	if err != nil {
+		return err
+	}
+	for name, ctrlr := range controllers2 {
+		if !available[name] {
+			log.Debugf("Skipping unavailable cgroup controller %q", name)
+			continue
+		}
+		if err := ctrlr.set(res, path); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *Cgroup) updateV2(res *specs.LinuxResources) error {
+	if !c.Own[unifiedKey] {
+		return nil
+	}
+	log.Debugf("Updating cgroup %q", c.unifiedPath())
+	return c.setV2(res)
+}
+
+func (c *Cgroup) uninstallV2() error {
+	if !c.Own[unifiedKey] {
+		// cgroup is managed by caller, don't touch it.
+		return nil
+	}
+	if c.Unit != "" {
+		// systemd removes the cgroup with the unit.
+		return stopSystemdUnit(c.Unit)
+	}
+	path := c.unifiedPath()
+	log.Debugf("Removing cgroup %q", path)
+
+	// If we try to remove the cgroup too soon after killing the sandbox we
+	// might get EBUSY, so we retry for a few seconds until it succeeds.
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	b := backoff.WithContext(backoff.NewConstantBackOff(100*time.Millisecond), ctx)
+	fn := func() error {
+		err := syscall.Rmdir(path)
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	if err := backoff.Retry(fn, b); err != nil {
+		return fmt.Errorf("removing cgroup path %q: %w", path, err)
+	}
+	return nil
+}
+
+func (c *Cgroup) joinV2() (func(), error) {
+	undo := func() {}
+	cur, err := loadUnifiedPath("self")
+	if err != nil {
+		return undo, err
+	}
+	undo = func() {
+		path := filepath.Join(cgroupRoot, cur)
+		log.Debugf("Restoring cgroup %q", path)
+		if err := setValue(path, "cgroup.procs", "0"); err != nil {
+			log.Warningf("Error restoring cgroup %q: %v", path, err)
+		}
+	}
+
+	path := c.unifiedPath()
+	log.Debugf("Joining cgroup %q", path)
+	if err := setValue(path, "cgroup.procs", "0"); err != nil {
+		return undo, err
+	}
+	return undo, nil
+}
+
+func (c *Cgroup) cpuQuotaV2() (float64, error) {
+	data, err := getValue(c.unifiedPath(), "cpu.max")
+	if err != nil {
+		return -1, err
+	}
+	// Format: "<quota|max> <period>".
+	fields := strings.Fields(data)
+	if len(fields) != 2 || fields[0] == "max" {
+		return -1, nil
+	}
+	quota, err := strconv.ParseInt(fields[0], 10, 64)
+	if err != nil {
+		return -1, err
+	}
+	period, err := strconv.ParseInt(fields[1], 10, 64)
+	if err != nil {
+		return -1, err
+	}
+	if quota <= 0 || period <= 0 {
+		return -1, nil
+	}
+	return float64(quota) / float64(period), nil
+}
+
+func (c *Cgroup) numCPUV2() (int, error) {
+	cpuset, err := getValue(c.unifiedPath(), "cpuset.cpus.effective")
+	if err != nil {
+		return 0, err
+	}
+	return countCpuset(strings.TrimSpace(cpuset))
+}
+
+func (c *Cgroup) memoryLimitV2() (uint64, error) {
+	limStr, err := getValue(c.unifiedPath(), "memory.max")
+	if err != nil {
+		return 0, err
+	}
+	limStr = strings.TrimSpace(limStr)
+	if limStr == "max" {
+		return math.MaxUint64, nil
+	}
+	return strconv.ParseUint(limStr, 10, 64)
+}
+
+// setOptionalMax writes a limit to a cgroup v2 file, where -1 means no limit.
+func setOptionalMax(path, name string, val *int64) error {
+	if val == nil || *val == 0 {
+		return nil
+	}
+	str := "max"
+	if *val > 0 {
+		str = strconv.FormatInt(*val, 10)
+	}
+	return setValue(path, name, str)
+}
+
+type cpu2 struct{}
+
+func (*cpu2) set(spec *specs.LinuxResources, path string) error {
+	if spec == nil || spec.CPU == nil {
+		return nil
+	}
+	if spec.CPU.Shares != nil && *spec.CPU.Shares != 0 {
+		if err := setValue(path, "cpu.weight", strconv.FormatUint(sharesToWeight(*spec.CPU.Shares), 10)); err != nil {
+			return err
+		}
+	}
+	if spec.CPU.Quota != nil || spec.CPU.Period != nil {
+		quota := "max"
+		if spec.CPU.Quota != nil && *spec.CPU.Quota > 0 {
+			quota = strconv.FormatInt(*spec.CPU.Quota, 10)
+		}
+		var period uint64 = 100000
+		if spec.CPU.Period != nil && *spec.CPU.Period != 0 {
+			period = *spec.CPU.Period
+		}
+		if err := setValue(path, "cpu.max", fmt.Sprintf("%s %d", quota, period)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// sharesToWeight converts cgroup v1 cpu.shares, in [2, 262144], to cgroup v2
+// cpu.weight, in [1, 10000].
+func sharesToWeight(shares uint64) uint64 {
+	if shares < 2 {
+		shares = 2
+	} else if shares > 262144 {
+		shares = 262144
+	}
+	return 1 + ((shares-2)*9999)/262142
+}
+
+type cpuSet2 struct{}
+
+func (*cpuSet2) set(spec *specs.LinuxResources, path string) error {
+	// Unlike cgroup v1, empty cpuset.cpus and cpuset.mems use the effective
+	// values of the parent, so they don't need to be filled.
+	if spec == nil || spec.CPU == nil {
+		return nil
+	}
+	if spec.CPU.Cpus != "" {
+		if err := setValue(path, "cpuset.cpus", spec.CPU.Cpus); err != nil {
+			return err
+		}
+	}
+	if spec.CPU.Mems != "" {
+		if err := setValue(path, "cpuset.mems", spec.CPU.Mems); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type io2 struct{}
+
+func (*io2) set(spec *specs.LinuxResources, path string) error {
+	if spec == nil || spec.BlockIO == nil {
+		return nil
+	}
+	if spec.BlockIO.Weight != nil && *spec.BlockIO.Weight != 0 {
+		val := fmt.Sprintf("default %d", blkioWeightToIOWeight(*spec.BlockIO.Weight))
+		if err := setValue(path, "io.weight", val); err != nil {
+			return err
+		}
+	}
+	for _, dev := range spec.BlockIO.WeightDevice {
+		if dev.Weight != nil {
+			val := fmt.Sprintf("%d:%d %d", dev.Major, dev.Minor, blkioWeightToIOWeight(*dev.Weight))
+			if err := setValue(path, "io.weight", val); err != nil {
+				return err
+			}
+		}
+	}
+	for _, t := range []struct {
+		key  string
+		devs []specs.LinuxThrottleDevice
+	}{
+		{"rbps", spec.BlockIO.ThrottleReadBpsDevice},
+		{"wbps", spec.BlockIO.ThrottleWriteBpsDevice},
+		{"riops", spec.BlockIO.ThrottleReadIOPSDevice},
+		{"wiops", spec.BlockIO.ThrottleWriteIOPSDevice},
+	} {
+		for _, dev := range t.devs {
+			rate := "max"
+			if dev.Rate > 0 {
+				rate = strconv.FormatUint(dev.Rate, 10)
+			}
+			val := fmt.Sprintf("%d:%d %s=%s", dev.Major, dev.Minor, t.key, rate)
+			if err := setValue(path, "io.max", val); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// blkioWeightToIOWeight converts cgroup v1 blkio.weight, in [10, 1000], to
+// cgroup v2 io.weight, in [1, 10000].
+func blkioWeightToIOWeight(weight uint16) uint64 {
+	if weight < 10 {
+		weight = 10
+	} else if weight > 1000 {
+		weight = 1000
+	}
+	return 1 + (uint64(weight)-10)*9999/990
+}
+
+type memory2 struct{}
+
+func (*memory2) set(spec *specs.LinuxResources, path string) error {
+	if spec == nil || spec.Memory == nil {
+		return nil
+	}
+	if err := setOptionalMax(path, "memory.max", spec.Memory.Limit); err != nil {
+		return err
+	}
+	if err := setOptionalMax(path, "memory.low", spec.Memory.Reservation); err != nil {
+		return err
+	}
+	// In cgroup v1, the swap limit includes memory, in cgroup v2 it doesn't.
+	if swap := spec.Memory.Swap; swap != nil && *swap != 0 {
+		val := int64(-1)
+		if *swap > 0 {
+			limit := spec.Memory.Limit
+			if limit == nil || *limit <= 0 || *swap < *limit {
+				return fmt.Errorf("memory swap limit %d must be greater than the memory limit", *swap)
+			}
+			val = *swap - *limit
+			if val == 0 {
+				// setOptionalMax skips 0.
+				return setValue(path, "memory.swap.max", "0")
+			}
+		}
+		if err := setOptionalMax(path, "memory.swap.max", &val); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type hugeTLB2 struct{}
+
+func (*hugeTLB2) set(spec *specs.LinuxResources, path string) error {
+	if spec == nil {
+		return nil
+	}
+	for _, limit := range spec.HugepageLimits {
+		name := fmt.Sprintf("hugetlb.%s.max", limit.Pagesize)
+		val := strconv.FormatUint(limit.Limit, 10)
+		if err := setValue(path, name, val); err != nil {
+			return err
+		}
+	}
+	return nil
+}
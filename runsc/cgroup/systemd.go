@@ -0,0 +1,156 @@
+// Copyright 2020 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cgroup
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cenkalti/backoff"
+	"gvisor.dev/gvisor/pkg/log"
+)
+
+// defaultSlice is the slice of systemd scopes whose cgroups path doesn't
+// name one.
+const defaultSlice = "system.slice"
+
+// parseSystemdPath parses a cgroups path of the form "slice:prefix:name", as
+// used with the systemd cgroup driver, into the slice and the name of the
+// scope unit that holds the cgroup.
+func parseSystemdPath(path string) (slice, unit string, err error) {
+	parts := strings.Split(path, ":")
+	if len(parts) != 3 {
+		return "", "", fmt.Errorf("invalid systemd cgroups path %q, want slice:prefix:name", path)
+	}
+	slice, prefix, name := parts[0], parts[1], parts[2]
+	if slice == "" {
+		slice = defaultSlice
+	}
+	if name == "" {
+		return "", "", fmt.Errorf("invalid systemd cgroups path %q, name is empty", path)
+	}
+	unit = name + ".scope"
+	if prefix != "" {
+		unit = prefix + "-" + unit
+	}
+	return slice, unit, nil
+}
+
+// expandSlice returns the path of the cgroup of a systemd slice, relative to
+// the cgroup root. Slices are nested by the dashes in their names, e.g.
+// "a-b.slice" is in "/a.slice/a-b.slice".
+func expandSlice(slice string) (string, error) {
+	const suffix = ".slice"
+	if !strings.HasSuffix(slice, suffix) || strings.Contains(slice, "/") {
+		return "", fmt.Errorf("invalid systemd slice %q", slice)
+	}
+	name := strings.TrimSuffix(slice, suffix)
+	if name == "-" {
+		// The root slice.
+		return "/", nil
+	}
+	path := "/"
+	prefix := ""
+	for _, component := range strings.Split(name, "-") {
+		if component == "" {
+			return "", fmt.Errorf("invalid systemd slice %q", slice)
+		}
+		path = filepath.Join(path, prefix+component+suffix)
+		prefix += component + "-"
+	}
+	return path, nil
+}
+
+// busctl calls a method of systemd's manager through D-Bus. It connects to the
+// user's systemd instance when runsc doesn't run as root.
+func busctl(method, signature string, args ...string) error {
+	cmdArgs := []string{"call", "--quiet"}
+	if os.Geteuid() != 0 {
+		cmdArgs = append(cmdArgs, "--user")
+	}
+	cmdArgs = append(cmdArgs, "org.freedesktop.systemd1", "/org/freedesktop/systemd1", "org.freedesktop.systemd1.Manager", method, signature)
+	cmdArgs = append(cmdArgs, args...)
+	out, err := exec.Command("busctl", cmdArgs...).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("calling systemd %s: %v: %s", method, err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+// startSystemdScope starts a transient systemd scope unit in slice, which
+// holds the process with the given PID. The scope delegates its cgroup to
+// runsc, so that systemd doesn't change the configuration written to it, and
+// enables the accounting of all resources, so that the slice enables their
+// controllers. It returns false if the unit already exists.
+func startSystemdScope(unit, slice string, pid int) (bool, error) {
+	log.Debugf("Starting systemd scope %q in slice %q", unit, slice)
+	props := [][]string{
+		{"Description", "s", "gVisor sandbox " + unit},
+		{"Slice", "s", slice},
+		{"Delegate", "b", "true"},
+		{"DefaultDependencies", "b", "false"},
+		{"CPUAccounting", "b", "true"},
+		{"IOAccounting", "b", "true"},
+		{"MemoryAccounting", "b", "true"},
+		{"TasksAccounting", "b", "true"},
+		{"PIDs", "au", "1", strconv.Itoa(pid)},
+	}
+	args := []string{unit, "replace", strconv.Itoa(len(props))}
+	for _, p := range props {
+		args = append(args, p...)
+	}
+	// No auxiliary units.
+	args = append(args, "0")
+	if err := busctl("StartTransientUnit", "ssa(sv)a(sa(sv))", args...); err != nil {
+		if strings.Contains(err.Error(), "already exists") {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, nil
+}
+
+// stopSystemdUnit stops a systemd unit, which removes its cgroup.
+func stopSystemdUnit(unit string) error {
+	log.Debugf("Stopping systemd unit %q", unit)
+	if err := busctl("StopUnit", "ss", unit, "replace"); err != nil {
+		if strings.Contains(err.Error(), "not loaded") {
+			return nil
+		}
+		return err
+	}
+	return nil
+}
+
+// waitForCgroup waits for systemd to create the cgroup at path, since units
+// are started asynchronously.
+func waitForCgroup(path string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	b := backoff.WithContext(backoff.NewConstantBackOff(10*time.Millisecond), ctx)
+	if err := backoff.Retry(func() error {
+		_, err := os.Stat(path)
+		return err
+	}, b); err != nil {
+		return fmt.Errorf("waiting for systemd to create cgroup %q: %w", path, err)
+	}
+	return nil
+}
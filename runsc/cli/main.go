@@ -28,6 +28,7 @@ import (
 
 	"github.com/google/subcommands"
 	"gvisor.dev/gvisor/pkg/log"
+	"gvisor.dev/gvisor/pkg/otlp"
 	"gvisor.dev/gvisor/pkg/refs"
 	"gvisor.dev/gvisor/pkg/sentry/platform"
 	"gvisor.dev/gvisor/runsc/cmd"
@@ -39,9 +40,7 @@ import (
 var (
 	// Although these flags are not part of the OCI spec, they are used by
 	// Docker, and thus should not be changed.
-	// TODO(gvisor.dev/issue/193): support systemd cgroups
-	systemdCgroup = flag.Bool("systemd-cgroup", false, "Use systemd for cgroups. NOT SUPPORTED.")
-	showVersion   = flag.Bool("version", false, "show version and exit.")
+	showVersion = flag.Bool("version", false, "show version and exit.")
 
 	// These flags are unique to runsc, and are used to configure parts of the
 	// system that are not covered by the runtime spec.
@@ -67,6 +66,7 @@ func Main(version string) {
 
 	// Register user-facing runsc commands.
 	subcommands.Register(new(cmd.Checkpoint), "")
+	subcommands.Register(new(cmd.ControlServer), "")
 	subcommands.Register(new(cmd.Create), "")
 	subcommands.Register(new(cmd.Delete), "")
 	subcommands.Register(new(cmd.Do), "")
@@ -75,7 +75,9 @@ func Main(version string) {
 	subcommands.Register(new(cmd.Gofer), "")
 	subcommands.Register(new(cmd.Kill), "")
 	subcommands.Register(new(cmd.List), "")
+	subcommands.Register(new(cmd.Migrate), "")
 	subcommands.Register(new(cmd.Pause), "")
+	subcommands.Register(new(cmd.Prefetch), "")
 	subcommands.Register(new(cmd.PS), "")
 	subcommands.Register(new(cmd.Restore), "")
 	subcommands.Register(new(cmd.Resume), "")
@@ -84,6 +86,7 @@ func Main(version string) {
 	subcommands.Register(new(cmd.State), "")
 	subcommands.Register(new(cmd.Start), "")
 	subcommands.Register(new(cmd.Symbolize), "")
+	subcommands.Register(new(cmd.Update), "")
 	subcommands.Register(new(cmd.Wait), "")
 
 	// Register internal commands with the internal group name. This causes
@@ -92,6 +95,7 @@ func Main(version string) {
 	const internalGroup = "internal use only"
 	subcommands.Register(new(cmd.Boot), internalGroup)
 	subcommands.Register(new(cmd.Debug), internalGroup)
+	subcommands.Register(new(cmd.Fetcher), internalGroup)
 	subcommands.Register(new(cmd.Gofer), internalGroup)
 	subcommands.Register(new(cmd.Statefile), internalGroup)
 
@@ -114,12 +118,6 @@ func Main(version string) {
 		cmd.Fatalf(err.Error())
 	}
 
-	// TODO(gvisor.dev/issue/193): support systemd cgroups
-	if *systemdCgroup {
-		fmt.Fprintln(os.Stderr, "systemd cgroup flag passed, but systemd cgroups not supported. See gvisor.dev/issue/193")
-		os.Exit(1)
-	}
-
 	var errorLogger io.Writer
 	if *logFD > -1 {
 		errorLogger = os.NewFile(uintptr(*logFD), "error log file")
@@ -189,8 +187,8 @@ func Main(version string) {
 		}
 		// Quick sanity check to make sure no other commands get passed
 		// a log fd (they should use log dir instead).
-		if subcommand != "boot" && subcommand != "gofer" {
-			cmd.Fatalf("flags --debug-log-fd and --panic-log-fd should only be passed to 'boot' and 'gofer' command, but was passed to %q", subcommand)
+		if subcommand != "boot" && subcommand != "gofer" && subcommand != "fetcher" {
+			cmd.Fatalf("flags --debug-log-fd and --panic-log-fd should only be passed to 'boot', 'gofer' and 'fetcher' command, but was passed to %q", subcommand)
 		}
 
 		// If we are the boot process, then we own our stdio FDs and can do what we
@@ -206,6 +204,17 @@ func Main(version string) {
 
 	log.SetTarget(e)
 
+	// The sandbox exports its spans to a file given by the parent process, and
+	// the gofer and the fetcher don't export any.
+	if conf.OTLPTraceFile != "" && subcommand != "boot" && subcommand != "gofer" && subcommand != "fetcher" {
+		f, err := specutils.DebugLogFile(conf.OTLPTraceFile, subcommand, "" /* name */)
+		if err != nil {
+			cmd.Fatalf("error opening OTLP trace file in %q: %v", conf.OTLPTraceFile, err)
+		}
+		exporter := otlp.NewExporter(f, otlp.String("service.name", "runsc"), otlp.String("runsc.command", subcommand))
+		otlp.SetDefault(otlp.NewTracer(exporter, otlp.SpanContext{}))
+	}
+
 	log.Infof("***************************")
 	log.Infof("Args: %s", os.Args)
 	log.Infof("Version %s", version)
@@ -18,6 +18,7 @@ import (
 	"context"
 	"os"
 	"runtime/debug"
+	"strconv"
 	"strings"
 	"syscall"
 
@@ -55,6 +56,10 @@ type Boot struct {
 	// provided in that order.
 	stdioFDs intFlags
 
+	// listenFDs are the files passed to the root container by socket
+	// activation, in order.
+	listenFDs intFlags
+
 	// applyCaps determines if capabilities defined in the spec should be applied
 	// to the process.
 	applyCaps bool
@@ -65,6 +70,9 @@ type Boot struct {
 	// cpuNum number of CPUs to create inside the sandbox.
 	cpuNum int
 
+	// maxCPUNum is the number of CPUs that the sandbox may be resized to.
+	maxCPUNum int
+
 	// totalMem sets the initial amount of total memory to report back to the
 	// container.
 	totalMem uint64
@@ -80,6 +88,45 @@ type Boot struct {
 	// sandbox (e.g. gofer) and sent through this FD.
 	mountsFD int
 
+	// overlayUpperFD is the file descriptor of the host file in which the
+	// upper layer of the root overlay is persisted, or 0.
+	overlayUpperFD int
+
+	// tmpfsSpillFD is the file descriptor of the host file in which tmpfs
+	// data is spilled, or 0.
+	tmpfsSpillFD int
+
+	// goferReadCacheFD is the file descriptor of the host file in which the
+	// contents of files read from gofer mounts are cached, or 0.
+	goferReadCacheFD int
+
+	// attestationFD is the file descriptor of the host's attestation device,
+	// named by attestationDevice, or -1.
+	attestationFD     int
+	attestationDevice string
+
+	// kfdFD is the file descriptor of the host's /dev/kfd, or 0.
+	kfdFD int
+
+	// otlpTraceFD is the file descriptor to which spans are exported, or 0.
+	otlpTraceFD int
+
+	// profileRingFD is the file descriptor of the host file that holds
+	// continuous profiles, or 0.
+	profileRingFD int
+
+	// coreDumpFD is the file descriptor of the pipe to the host core dump
+	// collector, or 0.
+	coreDumpFD int
+
+	// watchdogCheckpointFD is the file descriptor of the host file to which
+	// the watchdog checkpoints the sandbox, or 0.
+	watchdogCheckpointFD int
+
+	// exitStatusFD is the file descriptor of the host file to which the exit
+	// status of the sandbox is written, or -1.
+	exitStatusFD int
+
 	// pidns is set if the sandbox is in its own pid namespace.
 	pidns bool
 
@@ -112,14 +159,27 @@ func (b *Boot) SetFlags(f *flag.FlagSet) {
 	f.IntVar(&b.deviceFD, "device-fd", -1, "FD for the platform device file")
 	f.Var(&b.ioFDs, "io-fds", "list of FDs to connect 9P clients. They must follow this order: root first, then mounts as defined in the spec")
 	f.Var(&b.stdioFDs, "stdio-fds", "list of FDs containing sandbox stdin, stdout, and stderr in that order")
+	f.Var(&b.listenFDs, "listen-fds", "list of FDs passed to the container by socket activation, starting at FD 3 in the container")
 	f.BoolVar(&b.applyCaps, "apply-caps", false, "if true, apply capabilities defined in the spec to the process")
 	f.BoolVar(&b.setUpRoot, "setup-root", false, "if true, set up an empty root for the process")
 	f.BoolVar(&b.pidns, "pidns", false, "if true, the sandbox is in its own PID namespace")
 	f.IntVar(&b.cpuNum, "cpu-num", 0, "number of CPUs to create inside the sandbox")
+	f.IntVar(&b.maxCPUNum, "max-cpu-num", 0, "number of CPUs that the sandbox may be resized to")
 	f.Uint64Var(&b.totalMem, "total-memory", 0, "sets the initial amount of total memory to report back to the container")
 	f.IntVar(&b.userLogFD, "user-log-fd", 0, "file descriptor to write user logs to. 0 means no logging.")
 	f.IntVar(&b.startSyncFD, "start-sync-fd", -1, "required FD to used to synchronize sandbox startup")
 	f.IntVar(&b.mountsFD, "mounts-fd", -1, "mountsFD is the file descriptor to read list of mounts after they have been resolved (direct paths, no symlinks).")
+	f.IntVar(&b.overlayUpperFD, "overlay-upper-fd", 0, "FD of the host file in which the upper layer of the root overlay is persisted. 0 means the upper layer is kept in memory.")
+	f.IntVar(&b.tmpfsSpillFD, "tmpfs-spill-fd", 0, "FD of the host file in which tmpfs data is spilled. 0 means no spilling.")
+	f.IntVar(&b.goferReadCacheFD, "gofer-read-cache-fd", 0, "FD of the host file in which files read from gofer mounts are cached. 0 means no cache.")
+	f.IntVar(&b.attestationFD, "attestation-fd", -1, "FD of the host's confidential computing attestation device")
+	f.StringVar(&b.attestationDevice, "attestation-device", "", "name of the device given by --attestation-fd: sev-guest or tdx_guest")
+	f.IntVar(&b.kfdFD, "kfd-fd", 0, "FD of the host's /dev/kfd. 0 means /dev/kfd isn't exposed.")
+	f.IntVar(&b.otlpTraceFD, "otlp-trace-fd", 0, "FD to which OpenTelemetry spans are written as OTLP/JSON. 0 means spans aren't exported.")
+	f.IntVar(&b.profileRingFD, "profile-ring-fd", 0, "FD of the host file in which continuous profiles are kept. 0 means continuous profiling is disabled.")
+	f.IntVar(&b.coreDumpFD, "core-dump-fd", 0, "FD of the pipe to which core dumps are written for the --core-pattern command. 0 means core dumps aren't piped.")
+	f.IntVar(&b.watchdogCheckpointFD, "watchdog-checkpoint-fd", 0, "FD of the host file to which the watchdog checkpoints the sandbox. 0 means the watchdog doesn't checkpoint.")
+	f.IntVar(&b.exitStatusFD, "exit-status-fd", -1, "FD of the host file to which the exit status of the sandbox is written")
 	f.BoolVar(&b.attached, "attached", false, "if attached is true, kills the sandbox process when the parent process terminates")
 }
 
@@ -146,7 +206,13 @@ func (b *Boot) Execute(_ context.Context, f *flag.FlagSet, args ...interface{})
 	}
 
 	if b.setUpRoot {
-		if err := setUpChroot(b.pidns); err != nil {
+		// DNS-over-TLS servers are verified with the CA certificates of the
+		// host.
+		dnsStub, err := boot.NewDNSStubConfig(conf)
+		if err != nil {
+			Fatalf("configuring DNS stub resolver: %v", err)
+		}
+		if err := setUpChroot(b.pidns, dnsStub != nil && dnsStub.UsesTLS()); err != nil {
 			Fatalf("error setting up chroot: %v", err)
 		}
 
@@ -218,16 +284,29 @@ func (b *Boot) Execute(_ context.Context, f *flag.FlagSet, args ...interface{})
 
 	// Create the loader.
 	bootArgs := boot.Args{
-		ID:           f.Arg(0),
-		Spec:         spec,
-		Conf:         conf,
-		ControllerFD: b.controllerFD,
-		Device:       os.NewFile(uintptr(b.deviceFD), "platform device"),
-		GoferFDs:     b.ioFDs.GetArray(),
-		StdioFDs:     b.stdioFDs.GetArray(),
-		NumCPU:       b.cpuNum,
-		TotalMem:     b.totalMem,
-		UserLogFD:    b.userLogFD,
+		ID:                f.Arg(0),
+		Spec:              spec,
+		Conf:              conf,
+		ControllerFD:      b.controllerFD,
+		Device:            os.NewFile(uintptr(b.deviceFD), "platform device"),
+		GoferFDs:          b.ioFDs.GetArray(),
+		StdioFDs:          b.stdioFDs.GetArray(),
+		ListenFDs:         b.listenFDs.GetArray(),
+		NumCPU:            b.cpuNum,
+		MaxCPU:            b.maxCPUNum,
+		TotalMem:          b.totalMem,
+		UserLogFD:         b.userLogFD,
+		OverlayUpperFD:    b.overlayUpperFD,
+		TmpfsSpillFD:      b.tmpfsSpillFD,
+		GoferReadCacheFD:  b.goferReadCacheFD,
+		AttestationFD:     b.attestationFD,
+		AttestationDevice: b.attestationDevice,
+		KFDFD:             b.kfdFD,
+		OTLPTraceFD:       b.otlpTraceFD,
+		ProfileRingFD:     b.profileRingFD,
+		CoreDumpFD:        b.coreDumpFD,
+
+		WatchdogCheckpointFD: b.watchdogCheckpointFD,
 	}
 	l, err := boot.New(bootArgs)
 	if err != nil {
@@ -261,6 +340,13 @@ func (b *Boot) Execute(_ context.Context, f *flag.FlagSet, args ...interface{})
 	log.Infof("application exiting with %+v", ws)
 	waitStatus := args[1].(*syscall.WaitStatus)
 	*waitStatus = syscall.WaitStatus(ws.Status())
+	if b.exitStatusFD >= 0 {
+		exitStatusFile := os.NewFile(uintptr(b.exitStatusFD), "exit-status file")
+		if _, err := exitStatusFile.WriteString(strconv.Itoa(int(ws.Status()))); err != nil {
+			log.Warningf("Failed to write exit status: %v", err)
+		}
+		exitStatusFile.Close()
+	}
 	l.Destroy()
 	return subcommands.ExitSuccess
 }
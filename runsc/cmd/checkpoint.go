@@ -15,26 +15,44 @@
 package cmd
 
 import (
+	"bytes"
 	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
 	"os"
+	"os/exec"
 	"path/filepath"
 	"syscall"
 
 	"github.com/google/subcommands"
 	"gvisor.dev/gvisor/pkg/log"
+	"gvisor.dev/gvisor/pkg/state/statefile"
 	"gvisor.dev/gvisor/runsc/config"
 	"gvisor.dev/gvisor/runsc/container"
 	"gvisor.dev/gvisor/runsc/flag"
+	"gvisor.dev/gvisor/runsc/sandbox"
 	"gvisor.dev/gvisor/runsc/specutils"
 )
 
 // File containing the container's saved image/state within the given image-path's directory.
 const checkpointFileName = "checkpoint.img"
 
+// File containing the manifest of checkpointFileName, which is used to verify
+// the image before restoring it.
+const manifestFileName = "checkpoint.manifest"
+
+// minEncryptionKeySize is the minimum size of a checkpoint encryption key.
+const minEncryptionKeySize = 32
+
 // Checkpoint implements subcommands.Command for the "checkpoint" command.
 type Checkpoint struct {
-	imagePath    string
-	leaveRunning bool
+	imagePath          string
+	imageFD            int
+	leaveRunning       bool
+	encryption         stateEncryption
+	compression        string
+	compressionWorkers int
 }
 
 // Name implements subcommands.Command.Name.
@@ -50,13 +68,21 @@ func (*Checkpoint) Synopsis() string {
 // Usage implements subcommands.Command.Usage.
 func (*Checkpoint) Usage() string {
 	return `checkpoint [flags] <container id> - save current state of container.
+
+With --image-fd, the image is written sequentially to the given file descriptor, which may be a pipe or socket, so that it can be passed through other tools without being stored locally. Such images are restored with "runsc restore --image-fd".
+
+The saved image contains the container's memory, and so any secrets that it holds. Use --encryption-key-file or --encryption-key-command to encrypt it.
 `
 }
 
 // SetFlags implements subcommands.Command.SetFlags.
 func (c *Checkpoint) SetFlags(f *flag.FlagSet) {
 	f.StringVar(&c.imagePath, "image-path", "", "directory path to saved container image")
+	f.IntVar(&c.imageFD, "image-fd", -1, "file descriptor, such as a pipe or socket, to which the container image is streamed instead of image-path")
 	f.BoolVar(&c.leaveRunning, "leave-running", false, "restart the container after checkpointing")
+	c.encryption.setFlags(f)
+	f.StringVar(&c.compression, "compression", string(statefile.CompressionDefault), "compression of the checkpoint image: \"none\" is fastest, while \"flate-best-speed\", \"flate-default\" and \"flate-best-compression\" produce progressively smaller images with more CPU")
+	f.IntVar(&c.compressionWorkers, "compression-workers", 0, "number of threads that compress the checkpoint image, or 0 for one more than the number of CPUs; more threads shorten the time for which the container is paused")
 
 	// Unimplemented flags necessary for compatibility with docker.
 	var wp string
@@ -80,8 +106,44 @@ func (c *Checkpoint) Execute(_ context.Context, f *flag.FlagSet, args ...interfa
 		Fatalf("loading container: %v", err)
 	}
 
-	if c.imagePath == "" {
-		Fatalf("image-path flag must be provided")
+	switch {
+	case c.imageFD >= 0:
+		if c.imagePath != "" {
+			Fatalf("image-path and image-fd flags are mutually exclusive")
+		}
+		if c.leaveRunning {
+			Fatalf("leave-running can't be used with image-fd, since the image can't be read back")
+		}
+	case c.imagePath == "":
+		Fatalf("image-path or image-fd flag must be provided")
+	}
+
+	key, err := c.encryption.key()
+	if err != nil {
+		Fatalf("getting encryption key: %v", err)
+	}
+	compression, err := statefile.ParseCompression(c.compression)
+	if err != nil {
+		Fatalf("%v", err)
+	}
+	if c.compressionWorkers < 0 {
+		Fatalf("compression-workers must be at least 0")
+	}
+	opts := sandbox.CheckpointOpts{
+		Key:         key,
+		Compression: compression,
+		Workers:     c.compressionWorkers,
+	}
+
+	if c.imageFD >= 0 {
+		// The image can't be read back, so it has no manifest; its
+		// integrity is checked as it is restored.
+		file := os.NewFile(uintptr(c.imageFD), "checkpoint image")
+		defer file.Close()
+		if err := cont.Checkpoint(file, opts); err != nil {
+			Fatalf("checkpoint failed: %v", err)
+		}
+		return subcommands.ExitSuccess
 	}
 
 	if err := os.MkdirAll(c.imagePath, 0755); err != nil {
@@ -97,9 +159,12 @@ func (c *Checkpoint) Execute(_ context.Context, f *flag.FlagSet, args ...interfa
 	}
 	defer file.Close()
 
-	if err := cont.Checkpoint(file); err != nil {
+	if err := cont.Checkpoint(file, opts); err != nil {
 		Fatalf("checkpoint failed: %v", err)
 	}
+	if err := writeManifest(c.imagePath, file, key); err != nil {
+		Fatalf("writing manifest: %v", err)
+	}
 
 	if !c.leaveRunning {
 		return subcommands.ExitSuccess
@@ -144,6 +209,7 @@ func (c *Checkpoint) Execute(_ context.Context, f *flag.FlagSet, args ...interfa
 	}
 	defer cont.Destroy()
 
+	conf.RestoreKey = key
 	if err := cont.Restore(spec, conf, fullImagePath); err != nil {
 		Fatalf("starting container: %v", err)
 	}
@@ -156,3 +222,84 @@ func (c *Checkpoint) Execute(_ context.Context, f *flag.FlagSet, args ...interfa
 
 	return subcommands.ExitSuccess
 }
+
+// stateEncryption holds the flags that provide the key with which checkpoint
+// images are encrypted.
+type stateEncryption struct {
+	keyFile    string
+	keyCommand string
+}
+
+func (e *stateEncryption) setFlags(f *flag.FlagSet) {
+	f.StringVar(&e.keyFile, "encryption-key-file", "", "file containing the key with which the checkpoint image is encrypted and authenticated")
+	f.StringVar(&e.keyCommand, "encryption-key-command", "", "shell command that prints the key with which the checkpoint image is encrypted and authenticated, e.g. to decrypt a data key with a KMS")
+}
+
+// key returns the encryption key, or nil if none was given. Trailing newlines
+// are removed from the key.
+func (e *stateEncryption) key() ([]byte, error) {
+	var (
+		key []byte
+		err error
+	)
+	switch {
+	case e.keyFile != "" && e.keyCommand != "":
+		return nil, fmt.Errorf("encryption-key-file and encryption-key-command flags are mutually exclusive")
+	case e.keyFile != "":
+		key, err = ioutil.ReadFile(e.keyFile)
+	case e.keyCommand != "":
+		cmd := exec.Command("/bin/sh", "-c", e.keyCommand)
+		cmd.Stderr = os.Stderr
+		key, err = cmd.Output()
+	default:
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	key = bytes.TrimRight(key, "\r\n")
+	if len(key) < minEncryptionKeySize {
+		return nil, fmt.Errorf("key is %d bytes, must be at least %d", len(key), minEncryptionKeySize)
+	}
+	return key, nil
+}
+
+// writeManifest writes the manifest of the checkpoint image f to imagePath.
+func writeManifest(imagePath string, f *os.File, key []byte) error {
+	if _, err := f.Seek(0, 0); err != nil {
+		return err
+	}
+	m, err := statefile.NewManifest(f, key)
+	if err != nil {
+		return err
+	}
+	b, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filepath.Join(imagePath, manifestFileName), b, 0644)
+}
+
+// verifyManifest checks the checkpoint image in imagePath against its
+// manifest. The manifest is required if key is non-empty; otherwise, images
+// without one are accepted.
+func verifyManifest(imagePath string, key []byte) error {
+	b, err := ioutil.ReadFile(filepath.Join(imagePath, manifestFileName))
+	if os.IsNotExist(err) && len(key) == 0 {
+		log.Warningf("Checkpoint image in %q has no manifest, not verifying it", imagePath)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var m statefile.Manifest
+	if err := json.Unmarshal(b, &m); err != nil {
+		return fmt.Errorf("parsing manifest: %v", err)
+	}
+	f, err := os.Open(filepath.Join(imagePath, checkpointFileName))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return m.Verify(f, key)
+}
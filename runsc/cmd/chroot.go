@@ -36,6 +36,31 @@ func mountInChroot(chroot, src, dst, typ string, flags uint32) error {
 	return nil
 }
 
+// caCertFiles are the CA certificate bundles of the common distributions, as
+// looked up by crypto/x509.
+var caCertFiles = []string{
+	"/etc/ssl/certs/ca-certificates.crt",                // Debian/Ubuntu/Gentoo etc.
+	"/etc/pki/tls/certs/ca-bundle.crt",                  // Fedora/RHEL 6
+	"/etc/ssl/ca-bundle.pem",                            // OpenSUSE
+	"/etc/pki/tls/cacert.pem",                           // OpenELEC
+	"/etc/pki/ca-trust/extracted/pem/tls-ca-bundle.pem", // CentOS/RHEL 7
+	"/etc/ssl/cert.pem",                                 // Alpine Linux
+}
+
+// mountCACerts mounts the CA certificate bundle of the host in the chroot, so
+// that the sandbox can verify TLS servers.
+func mountCACerts(chroot string) error {
+	for _, f := range caCertFiles {
+		// The bundle may be a symlink, which would dangle in the chroot.
+		src, err := filepath.EvalSymlinks(f)
+		if err != nil {
+			continue
+		}
+		return mountInChroot(chroot, src, f, "bind", syscall.MS_BIND|syscall.MS_RDONLY)
+	}
+	return fmt.Errorf("no CA certificates found in %v", caCertFiles)
+}
+
 func pivotRoot(root string) error {
 	if err := os.Chdir(root); err != nil {
 		return fmt.Errorf("error changing working directory: %v", err)
@@ -61,7 +86,7 @@ func pivotRoot(root string) error {
 
 // setUpChroot creates an empty directory with runsc mounted at /runsc and proc
 // mounted at /proc.
-func setUpChroot(pidns bool) error {
+func setUpChroot(pidns, caCerts bool) error {
 	// We are a new mount namespace, so we can use /tmp as a directory to
 	// construct a new root.
 	chroot := os.TempDir()
@@ -89,6 +114,12 @@ func setUpChroot(pidns bool) error {
 		}
 	}
 
+	if caCerts {
+		if err := mountCACerts(chroot); err != nil {
+			return err
+		}
+	}
+
 	if err := syscall.Mount("", chroot, "", syscall.MS_REMOUNT|syscall.MS_RDONLY|syscall.MS_BIND, ""); err != nil {
 		return fmt.Errorf("error remounting chroot in read-only: %v", err)
 	}
@@ -0,0 +1,331 @@
+// Copyright 2021 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"context"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"github.com/google/subcommands"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"gvisor.dev/gvisor/pkg/log"
+	"gvisor.dev/gvisor/pkg/sentry/control"
+	"gvisor.dev/gvisor/pkg/state/statefile"
+	"gvisor.dev/gvisor/runsc/config"
+	"gvisor.dev/gvisor/runsc/container"
+	"gvisor.dev/gvisor/runsc/controlapi"
+	pb "gvisor.dev/gvisor/runsc/controlapi/control_go_proto"
+	"gvisor.dev/gvisor/runsc/flag"
+	"gvisor.dev/gvisor/runsc/sandbox"
+)
+
+// ControlServer implements subcommands.Command for the "control-server"
+// command.
+type ControlServer struct {
+	address     string
+	tlsCert     string
+	tlsKey      string
+	clientCA    string
+	tokenFile   string
+	allowedUIDs string
+	encryption  stateEncryption
+}
+
+// Name implements subcommands.Command.Name.
+func (*ControlServer) Name() string {
+	return "control-server"
+}
+
+// Synopsis implements subcommands.Command.Synopsis.
+func (*ControlServer) Synopsis() string {
+	return "serve the control API of all containers over gRPC"
+}
+
+// Usage implements subcommands.Command.Usage.
+func (*ControlServer) Usage() string {
+	return `control-server [flags] - serve the control API of all containers over gRPC.
+
+The API, defined in runsc/controlapi/control.proto, lets agents list containers, query their state, checkpoint them and change their tracing without running runsc for each operation. It is served until the server is interrupted.
+
+With --address=unix:<path>, only the user running the server, and users listed in --allowed-uids, may connect. With --address=tcp:<host>:<port>, --tls-cert and --tls-key are required, along with --tls-client-ca to require client certificates, --token-file to require a bearer token, or both.
+`
+}
+
+// SetFlags implements subcommands.Command.SetFlags.
+func (s *ControlServer) SetFlags(f *flag.FlagSet) {
+	f.StringVar(&s.address, "address", "", `address to listen on: "unix:<path>" or "tcp:<host>:<port>"`)
+	f.StringVar(&s.tlsCert, "tls-cert", "", "file containing the PEM certificate of the server")
+	f.StringVar(&s.tlsKey, "tls-key", "", "file containing the PEM private key of the server")
+	f.StringVar(&s.clientCA, "tls-client-ca", "", "file containing the PEM certificates of the CAs that sign client certificates; clients must present one")
+	f.StringVar(&s.tokenFile, "token-file", "", "file containing a bearer token that clients must present")
+	f.StringVar(&s.allowedUIDs, "allowed-uids", "", "comma separated list of users, besides the one running the server, allowed to connect to a Unix socket")
+	s.encryption.setFlags(f)
+}
+
+// Execute implements subcommands.Command.Execute.
+func (s *ControlServer) Execute(_ context.Context, f *flag.FlagSet, args ...interface{}) subcommands.ExitStatus {
+	if f.NArg() != 0 || s.address == "" {
+		f.Usage()
+		return subcommands.ExitUsageError
+	}
+	conf := args[0].(*config.Config)
+
+	opts := controlapi.ServerOptions{
+		Address:      s.address,
+		CertFile:     s.tlsCert,
+		KeyFile:      s.tlsKey,
+		ClientCAFile: s.clientCA,
+	}
+	if s.tokenFile != "" {
+		token, err := controlapi.ReadToken(s.tokenFile)
+		if err != nil {
+			Fatalf("reading token: %v", err)
+		}
+		opts.Token = token
+	}
+	if s.allowedUIDs != "" {
+		for _, str := range strings.Split(s.allowedUIDs, ",") {
+			uid, err := strconv.ParseUint(str, 10, 32)
+			if err != nil {
+				Fatalf("invalid UID %q in allowed-uids", str)
+			}
+			opts.AllowedUIDs = append(opts.AllowedUIDs, uint32(uid))
+		}
+	}
+	key, err := s.encryption.key()
+	if err != nil {
+		Fatalf("getting encryption key: %v", err)
+	}
+
+	srv, l, err := controlapi.NewServer(opts)
+	if err != nil {
+		Fatalf("starting control API server: %v", err)
+	}
+	pb.RegisterControlServer(srv, &controlService{conf: conf, key: key})
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGTERM, syscall.SIGINT)
+	go func() {
+		<-signals
+		log.Infof("Caught signal, stopping control API server")
+		srv.GracefulStop()
+	}()
+
+	log.Infof("Serving control API %s on %q", controlapi.APIVersion, s.address)
+	if err := srv.Serve(l); err != nil {
+		Fatalf("serving control API: %v", err)
+	}
+	return subcommands.ExitSuccess
+}
+
+// controlService implements pb.ControlServer for the containers of a root
+// directory.
+type controlService struct {
+	pb.UnimplementedControlServer
+
+	conf *config.Config
+
+	// key, if non-empty, encrypts checkpoint images.
+	key []byte
+}
+
+// load loads a container, returning gRPC status errors.
+func (s *controlService) load(id string) (*container.Container, error) {
+	if id == "" {
+		return nil, status.Error(codes.InvalidArgument, "container ID is required")
+	}
+	c, err := container.Load(s.conf.RootDir, container.FullID{ContainerID: id}, container.LoadOpts{})
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, status.Errorf(codes.NotFound, "container %q not found", id)
+		}
+		return nil, status.Errorf(codes.Internal, "loading container %q: %v", id, err)
+	}
+	return c, nil
+}
+
+// loadRunning loads a container whose sandbox must be running.
+func (s *controlService) loadRunning(id string) (*container.Container, error) {
+	c, err := s.load(id)
+	if err != nil {
+		return nil, err
+	}
+	if !c.IsSandboxRunning() {
+		return nil, status.Errorf(codes.FailedPrecondition, "sandbox of container %q is not running", c.ID)
+	}
+	return c, nil
+}
+
+func containerToProto(c *container.Container) *pb.Container {
+	state := c.State()
+	pc := &pb.Container{
+		Id:               state.ID,
+		Status:           state.Status,
+		Pid:              int32(state.Pid),
+		Bundle:           state.Bundle,
+		CreatedUnixNanos: c.CreatedAt.UnixNano(),
+		Owner:            c.Owner,
+	}
+	if c.Sandbox != nil {
+		pc.SandboxId = c.Sandbox.ID
+	}
+	if c.Spec != nil {
+		pc.Annotations = c.Spec.Annotations
+	}
+	return pc
+}
+
+// Checkpoint implements pb.ControlServer.Checkpoint.
+func (s *controlService) Checkpoint(_ context.Context, req *pb.CheckpointRequest) (*pb.CheckpointResponse, error) {
+	if req.ImagePath == "" {
+		return nil, status.Error(codes.InvalidArgument, "image path is required")
+	}
+	compression := statefile.CompressionDefault
+	if req.Compression != "" {
+		var err error
+		if compression, err = statefile.ParseCompression(req.Compression); err != nil {
+			return nil, status.Error(codes.InvalidArgument, err.Error())
+		}
+	}
+	if req.CompressionWorkers < 0 {
+		return nil, status.Error(codes.InvalidArgument, "compression workers must be at least 0")
+	}
+	c, err := s.loadRunning(req.ContainerId)
+	if err != nil {
+		return nil, err
+	}
+
+	log.Infof("Control API: checkpointing container %q to %q", c.ID, req.ImagePath)
+	if err := os.MkdirAll(req.ImagePath, 0755); err != nil {
+		return nil, status.Errorf(codes.Internal, "making directories at path provided: %v", err)
+	}
+	fullImagePath := filepath.Join(req.ImagePath, checkpointFileName)
+	file, err := os.OpenFile(fullImagePath, os.O_CREATE|os.O_EXCL|os.O_RDWR, 0644)
+	if err != nil {
+		if os.IsExist(err) {
+			return nil, status.Errorf(codes.AlreadyExists, "%q already exists", fullImagePath)
+		}
+		return nil, status.Errorf(codes.Internal, "creating image: %v", err)
+	}
+	defer file.Close()
+
+	opts := sandbox.CheckpointOpts{
+		Key:         s.key,
+		Compression: compression,
+		Workers:     int(req.CompressionWorkers),
+	}
+	if err := c.Checkpoint(file, opts); err != nil {
+		return nil, status.Errorf(codes.Internal, "checkpoint failed: %v", err)
+	}
+	if err := writeManifest(req.ImagePath, file, s.key); err != nil {
+		return nil, status.Errorf(codes.Internal, "writing manifest: %v", err)
+	}
+	return &pb.CheckpointResponse{}, nil
+}
+
+// GetContainer implements pb.ControlServer.GetContainer.
+func (s *controlService) GetContainer(_ context.Context, req *pb.GetContainerRequest) (*pb.GetContainerResponse, error) {
+	c, err := s.load(req.ContainerId)
+	if err != nil {
+		return nil, err
+	}
+	return &pb.GetContainerResponse{Container: containerToProto(c)}, nil
+}
+
+// GetVersion implements pb.ControlServer.GetVersion.
+func (*controlService) GetVersion(context.Context, *pb.GetVersionRequest) (*pb.GetVersionResponse, error) {
+	return &pb.GetVersionResponse{ApiVersion: controlapi.APIVersion}, nil
+}
+
+// ListContainers implements pb.ControlServer.ListContainers.
+func (s *controlService) ListContainers(context.Context, *pb.ListContainersRequest) (*pb.ListContainersResponse, error) {
+	ids, err := container.List(s.conf.RootDir)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "listing containers: %v", err)
+	}
+	resp := &pb.ListContainersResponse{}
+	for _, id := range ids {
+		c, err := container.Load(s.conf.RootDir, id, container.LoadOpts{Exact: true})
+		if err != nil {
+			// The container may have been deleted since it was listed.
+			log.Warningf("Skipping container %q: %v", id, err)
+			continue
+		}
+		resp.Containers = append(resp.Containers, containerToProto(c))
+	}
+	return resp, nil
+}
+
+// SetTracing implements pb.ControlServer.SetTracing.
+func (s *controlService) SetTracing(_ context.Context, req *pb.SetTracingRequest) (*pb.SetTracingResponse, error) {
+	var args control.LoggingArgs
+	switch req.Strace {
+	case pb.SetTracingRequest_STRACE_UNCHANGED:
+	case pb.SetTracingRequest_STRACE_OFF:
+		args.SetStrace = true
+	case pb.SetTracingRequest_STRACE_ALL:
+		args.SetStrace = true
+		args.EnableStrace = true
+	case pb.SetTracingRequest_STRACE_SYSCALLS:
+		if len(req.StraceSyscalls) == 0 {
+			return nil, status.Error(codes.InvalidArgument, "strace syscalls are required")
+		}
+		args.SetStrace = true
+		args.EnableStrace = true
+		args.StraceWhitelist = req.StraceSyscalls
+	default:
+		return nil, status.Errorf(codes.InvalidArgument, "invalid strace mode %v", req.Strace)
+	}
+	switch req.LogLevel {
+	case pb.SetTracingRequest_LOG_LEVEL_UNCHANGED:
+	case pb.SetTracingRequest_LOG_LEVEL_WARNING:
+		args.SetLevel = true
+		args.Level = log.Warning
+	case pb.SetTracingRequest_LOG_LEVEL_INFO:
+		args.SetLevel = true
+		args.Level = log.Info
+	case pb.SetTracingRequest_LOG_LEVEL_DEBUG:
+		args.SetLevel = true
+		args.Level = log.Debug
+	default:
+		return nil, status.Errorf(codes.InvalidArgument, "invalid log level %v", req.LogLevel)
+	}
+	switch req.LogPackets {
+	case pb.SetTracingRequest_LOG_PACKETS_UNCHANGED:
+	case pb.SetTracingRequest_LOG_PACKETS_DISABLED:
+		args.SetLogPackets = true
+	case pb.SetTracingRequest_LOG_PACKETS_ENABLED:
+		args.SetLogPackets = true
+		args.LogPackets = true
+	default:
+		return nil, status.Errorf(codes.InvalidArgument, "invalid packet logging %v", req.LogPackets)
+	}
+
+	c, err := s.loadRunning(req.ContainerId)
+	if err != nil {
+		return nil, err
+	}
+	log.Infof("Control API: changing logging of sandbox %q: %+v", c.Sandbox.ID, args)
+	if err := c.Sandbox.ChangeLogging(args); err != nil {
+		return nil, status.Errorf(codes.Internal, "changing logging: %v", err)
+	}
+	return &pb.SetTracingResponse{}, nil
+}
@@ -22,6 +22,7 @@ import (
 	"gvisor.dev/gvisor/runsc/container"
 	"gvisor.dev/gvisor/runsc/flag"
 	"gvisor.dev/gvisor/runsc/specutils"
+	"gvisor.dev/gvisor/runsc/systemd"
 )
 
 // Create implements subcommands.Command for the "create" command.
@@ -97,6 +98,12 @@ func (c *Create) Execute(_ context.Context, f *flag.FlagSet, args ...interface{}
 	}
 	specutils.LogSpec(spec)
 
+	listenFiles, listenNames, err := systemd.ListenFiles()
+	if err != nil {
+		return Errorf("reading socket activation files: %v", err)
+	}
+	specutils.AddListenFDs(spec, len(listenFiles), listenNames)
+
 	// Create the container. A new sandbox will be created for the
 	// container unless the metadata specifies that it should be run in an
 	// existing container.
@@ -107,6 +114,7 @@ func (c *Create) Execute(_ context.Context, f *flag.FlagSet, args ...interface{}
 		ConsoleSocket: c.consoleSocket,
 		PIDFile:       c.pidFile,
 		UserLog:       c.userLog,
+		ListenFiles:   listenFiles,
 	}
 	if _, err := container.New(conf, contArgs); err != nil {
 		return Errorf("creating container: %v", err)
@@ -16,6 +16,7 @@ package cmd
 
 import (
 	"context"
+	"fmt"
 	"os"
 	"os/signal"
 	"strconv"
@@ -27,6 +28,8 @@ import (
 	"github.com/google/subcommands"
 	"gvisor.dev/gvisor/pkg/log"
 	"gvisor.dev/gvisor/pkg/sentry/control"
+	"gvisor.dev/gvisor/pkg/tcpip/link/capture"
+	"gvisor.dev/gvisor/runsc/boot"
 	"gvisor.dev/gvisor/runsc/config"
 	"gvisor.dev/gvisor/runsc/container"
 	"gvisor.dev/gvisor/runsc/flag"
@@ -41,6 +44,7 @@ type Debug struct {
 	profileCPU   string
 	profileBlock string
 	profileMutex string
+	profileDump  string
 	trace        string
 	strace       string
 	logLevel     string
@@ -48,6 +52,15 @@ type Debug struct {
 	delay        time.Duration
 	duration     time.Duration
 	ps           bool
+	fsStats      bool
+	syscallStats int
+	memBreakdown bool
+
+	pcap          string
+	pcapFilter    string
+	pcapSnapLen   uint
+	pcapFileSize  uint
+	pcapFileCount uint
 }
 
 // Name implements subcommands.Command.
@@ -73,14 +86,23 @@ func (d *Debug) SetFlags(f *flag.FlagSet) {
 	f.StringVar(&d.profileCPU, "profile-cpu", "", "writes CPU profile to the given file.")
 	f.StringVar(&d.profileBlock, "profile-block", "", "writes block profile to the given file.")
 	f.StringVar(&d.profileMutex, "profile-mutex", "", "writes mutex profile to the given file.")
+	f.StringVar(&d.profileDump, "profile-dump", "", "writes the recent profiles kept by continuous profiling to the given file, as a tar archive. Requires --profile-ring.")
 	f.DurationVar(&d.delay, "delay", time.Hour, "amount of time to delay for collecting heap and goroutine profiles.")
-	f.DurationVar(&d.duration, "duration", time.Hour, "amount of time to wait for CPU and trace profiles.")
+	f.DurationVar(&d.duration, "duration", time.Hour, "amount of time to wait for CPU and trace profiles, and packet captures.")
 	f.StringVar(&d.trace, "trace", "", "writes an execution trace to the given file.")
 	f.IntVar(&d.signal, "signal", -1, "sends signal to the sandbox")
 	f.StringVar(&d.strace, "strace", "", `A comma separated list of syscalls to trace. "all" enables all traces, "off" disables all.`)
 	f.StringVar(&d.logLevel, "log-level", "", "The log level to set: warning (0), info (1), or debug (2).")
 	f.StringVar(&d.logPackets, "log-packets", "", "A boolean value to enable or disable packet logging: true or false.")
 	f.BoolVar(&d.ps, "ps", false, "lists processes")
+	f.BoolVar(&d.fsStats, "fsstats", false, "dumps the file operation statistics of the container's mounts. Requires --fs-stats.")
+	f.IntVar(&d.syscallStats, "syscall-stats", 0, "dumps the syscall latency histograms of the sandbox and its given number of slowest syscalls of the last minute. Requires --syscall-stats.")
+	f.BoolVar(&d.memBreakdown, "memory-breakdown", false, "dumps the attribution of the sandbox's memory usage to application memory, the page cache, tmpfs, netstack buffers and the Go runtime.")
+	f.StringVar(&d.pcap, "pcap", "", "captures packets of the sandbox network stack to the given pcapng file.")
+	f.StringVar(&d.pcapFilter, "pcap-filter", "", `BPF program selecting the captured packets, as printed by "tcpdump -ddd -y RAW <expression>". Packets are matched from their IP header.`)
+	f.UintVar(&d.pcapSnapLen, "pcap-snaplen", capture.DefaultSnapLen, "maximum number of bytes captured from each packet.")
+	f.UintVar(&d.pcapFileSize, "pcap-file-size", 0, "maximum size of capture files in megabytes. Once reached, the capture continues in the next file. 0 means no limit.")
+	f.UintVar(&d.pcapFileCount, "pcap-file-count", 1, `number of capture files, named "<pcap>.<n>" if greater than 1. The oldest file is overwritten once all are used.`)
 }
 
 // Execute implements subcommands.Command.Execute.
@@ -214,14 +236,47 @@ func (d *Debug) Execute(_ context.Context, f *flag.FlagSet, args ...interface{})
 		}
 		log.Infof(o)
 	}
+	if d.fsStats {
+		stats, err := c.Sandbox.FSStats(c.ID)
+		if err != nil {
+			return Errorf("getting file system statistics: %v", err)
+		}
+		log.Infof("     *** File system statistics ***\n%s", stats)
+	}
+	if d.syscallStats > 0 {
+		stats, err := c.Sandbox.SyscallStats(d.syscallStats)
+		if err != nil {
+			return Errorf("getting syscall statistics: %v", err)
+		}
+		log.Infof("     *** Syscall statistics ***\n%s", stats)
+	}
+	if d.memBreakdown {
+		mb, err := c.Sandbox.MemoryBreakdown()
+		if err != nil {
+			return Errorf("getting memory breakdown: %v", err)
+		}
+		log.Infof("     *** Memory breakdown ***\n%s", mb)
+	}
+	if d.profileDump != "" {
+		f, err := os.OpenFile(d.profileDump, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+		if err != nil {
+			return Errorf("error opening profile dump output: %v", err)
+		}
+		defer f.Close()
+		if err := c.Sandbox.ProfileDump(f); err != nil {
+			return Errorf("dumping profiles: %v", err)
+		}
+		log.Infof("Profiles dumped to %q", d.profileDump)
+	}
 
-	// Open profiling files.
+	// Open profiling and capture files.
 	var (
 		heapFile  *os.File
 		cpuFile   *os.File
 		traceFile *os.File
 		blockFile *os.File
 		mutexFile *os.File
+		pcapArgs  *boot.CaptureArgs
 	)
 	if d.profileHeap != "" {
 		f, err := os.OpenFile(d.profileHeap, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
@@ -263,7 +318,42 @@ func (d *Debug) Execute(_ context.Context, f *flag.FlagSet, args ...interface{})
 		mutexFile = f
 	}
 
-	// Collect profiles.
+	if d.pcap != "" {
+		pcapArgs = &boot.CaptureArgs{
+			SnapLen:  uint32(d.pcapSnapLen),
+			FileSize: int64(d.pcapFileSize) << 20,
+			Duration: d.duration,
+		}
+		if d.pcapFilter != "" {
+			filter, err := capture.ParseFilter(d.pcapFilter)
+			if err != nil {
+				return Errorf("invalid pcap filter: %v", err)
+			}
+			pcapArgs.Filter = filter
+		}
+		if d.pcapFileCount == 0 {
+			return Errorf("pcap-file-count must be at least 1")
+		}
+		if d.pcapFileCount > 1 && d.pcapFileSize == 0 {
+			return Errorf("pcap-file-size must be set with multiple capture files")
+		}
+		for i := uint(0); i < d.pcapFileCount; i++ {
+			name := d.pcap
+			if d.pcapFileCount > 1 {
+				name = fmt.Sprintf("%s.%d", d.pcap, i)
+			}
+			f, err := os.OpenFile(name, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+			if err != nil {
+				return Errorf("error opening capture output: %v", err)
+			}
+			defer f.Close()
+			pcapArgs.FilePayload.Files = append(pcapArgs.FilePayload.Files, f)
+		}
+	} else if d.pcapFilter != "" {
+		return Errorf("pcap-filter requires pcap to be set")
+	}
+
+	// Collect profiles and packets.
 	var (
 		wg       sync.WaitGroup
 		heapErr  error
@@ -271,6 +361,7 @@ func (d *Debug) Execute(_ context.Context, f *flag.FlagSet, args ...interface{})
 		traceErr error
 		blockErr error
 		mutexErr error
+		pcapErr  error
 	)
 	if heapFile != nil {
 		wg.Add(1)
@@ -308,6 +399,18 @@ func (d *Debug) Execute(_ context.Context, f *flag.FlagSet, args ...interface{})
 		}()
 	}
 
+	if pcapArgs != nil {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			var packets uint64
+			packets, pcapErr = c.Sandbox.CapturePackets(pcapArgs)
+			if pcapErr == nil {
+				log.Infof("Captured %d packets", packets)
+			}
+		}()
+	}
+
 	// Before sleeping, allow us to catch signals and try to exit
 	// gracefully before just exiting. If we can't wait for wg, then
 	// we will not be able to read the errors below safely.
@@ -363,6 +466,14 @@ func (d *Debug) Execute(_ context.Context, f *flag.FlagSet, args ...interface{})
 		os.Remove(mutexFile.Name())
 	}
 
+	if pcapErr != nil {
+		errorCount++
+		log.Infof("error capturing packets: %v", pcapErr)
+		for _, f := range pcapArgs.FilePayload.Files {
+			os.Remove(f.Name())
+		}
+	}
+
 	if errorCount > 0 {
 		return subcommands.ExitFailure
 	}
@@ -22,6 +22,7 @@ import (
 
 	"github.com/google/subcommands"
 	"gvisor.dev/gvisor/pkg/log"
+	"gvisor.dev/gvisor/runsc/boot"
 	"gvisor.dev/gvisor/runsc/config"
 	"gvisor.dev/gvisor/runsc/container"
 	"gvisor.dev/gvisor/runsc/flag"
@@ -52,7 +53,8 @@ func (*Events) Usage() string {
 Where "<container-id>" is the name for the instance of the container.
 
 The events command displays information about the container. By default the
-information is displayed once every 5 seconds.
+information is displayed once every 5 seconds, followed by an event of type
+"stall" for each stall detected by the sandbox's watchdog since then.
 
 OPTIONS:
 `
@@ -80,6 +82,7 @@ func (evs *Events) Execute(ctx context.Context, f *flag.FlagSet, args ...interfa
 	}
 
 	// Repeatedly get stats from the container.
+	var lastStall uint64
 	for {
 		// Get the event and print it as JSON.
 		ev, err := c.Event()
@@ -109,6 +112,20 @@ func (evs *Events) Execute(ctx context.Context, f *flag.FlagSet, args ...interfa
 			return subcommands.ExitSuccess
 		}
 
+		stalls, err := c.StallEvents(lastStall)
+		if err != nil {
+			log.Warningf("Error getting stall events for container: %v", err)
+		}
+		for _, stall := range stalls {
+			lastStall = stall.Seq
+			b, err := json.Marshal(&boot.Event{Type: "stall", ID: id, Data: stall})
+			if err != nil {
+				log.Warningf("Error while marshalling stall event %v: %v", stall, err)
+				continue
+			}
+			os.Stdout.Write(b)
+		}
+
 		time.Sleep(time.Duration(evs.intervalSec) * time.Second)
 	}
 }
@@ -111,6 +111,11 @@ func (ex *Exec) Execute(_ context.Context, f *flag.FlagSet, args ...interface{})
 		Fatalf("parsing process spec: %v", err)
 	}
 	waitStatus := args[1].(*syscall.WaitStatus)
+	if conf.Podman {
+		if err := console.CheckSocket(e.StdioIsPty, !ex.detach, ex.consoleSocket); err != nil {
+			Fatalf("%v", err)
+		}
+	}
 
 	c, err := container.Load(conf.RootDir, container.FullID{ContainerID: id}, container.LoadOpts{})
 	if err != nil {
@@ -0,0 +1,74 @@
+// Copyright 2021 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"context"
+	"io"
+	"os"
+
+	"github.com/google/subcommands"
+	"gvisor.dev/gvisor/pkg/log"
+	"gvisor.dev/gvisor/runsc/flag"
+	"gvisor.dev/gvisor/runsc/lazyimage"
+)
+
+// Fetcher implements subcommands.Command for the "fetcher" command, which
+// fetches the files of a lazily loaded root image from its registry on behalf
+// of the gofer. This command should not be called directly.
+type Fetcher struct {
+	fds intFlags
+}
+
+// Name implements subcommands.Command.
+func (*Fetcher) Name() string {
+	return "fetcher"
+}
+
+// Synopsis implements subcommands.Command.
+func (*Fetcher) Synopsis() string {
+	return "launch a process that fetches files of lazily loaded images for the gofer (internal use only)"
+}
+
+// Usage implements subcommands.Command.
+func (*Fetcher) Usage() string {
+	return `fetcher [flags]`
+}
+
+// SetFlags implements subcommands.Command.
+func (fe *Fetcher) SetFlags(f *flag.FlagSet) {
+	f.Var(&fe.fds, "fds", "list of FDs connected to the gofer")
+}
+
+// Execute implements subcommands.Command.
+func (fe *Fetcher) Execute(_ context.Context, f *flag.FlagSet, args ...interface{}) subcommands.ExitStatus {
+	if len(fe.fds) == 0 {
+		f.Usage()
+		return subcommands.ExitUsageError
+	}
+
+	transport, err := lazyimage.NewTransport()
+	if err != nil {
+		Fatalf("creating transport: %v", err)
+	}
+	conns := make([]io.ReadWriteCloser, 0, len(fe.fds))
+	for _, fd := range fe.fds {
+		conns = append(conns, os.NewFile(uintptr(fd), "gofer connection"))
+	}
+	log.Infof("Fetching files for the gofer on FDs %v", fe.fds)
+	lazyimage.ServeFetcher(conns, transport)
+	log.Infof("All connections to the gofer are closed")
+	return subcommands.ExitSuccess
+}
@@ -18,6 +18,7 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"io"
 	"os"
 	"path/filepath"
 	"strings"
@@ -34,6 +35,7 @@ import (
 	"gvisor.dev/gvisor/runsc/flag"
 	"gvisor.dev/gvisor/runsc/fsgofer"
 	"gvisor.dev/gvisor/runsc/fsgofer/filter"
+	"gvisor.dev/gvisor/runsc/lazyimage"
 	"gvisor.dev/gvisor/runsc/specutils"
 )
 
@@ -54,6 +56,10 @@ var goferCaps = &specs.LinuxCapabilities{
 	Permitted: caps,
 }
 
+// lazyImageCacheDir is where the --lazy-image-cache directory is mounted in
+// the gofer's root.
+const lazyImageCacheDir = "/lazy-image-cache"
+
 // Gofer implements subcommands.Command for the "gofer" command, which starts a
 // filesystem gofer.  This command should not be called directly.
 type Gofer struct {
@@ -64,6 +70,10 @@ type Gofer struct {
 
 	specFD   int
 	mountsFD int
+
+	// fetcherFDs are connections to the process that fetches the files of
+	// the lazily loaded root image from its registry.
+	fetcherFDs intFlags
 }
 
 // Name implements subcommands.Command.
@@ -89,6 +99,7 @@ func (g *Gofer) SetFlags(f *flag.FlagSet) {
 	f.BoolVar(&g.setUpRoot, "setup-root", true, "if true, set up an empty root for the process")
 	f.IntVar(&g.specFD, "spec-fd", -1, "required fd with the container spec")
 	f.IntVar(&g.mountsFD, "mounts-fd", -1, "mountsFD is the file descriptor to write list of mounts after they have been resolved (direct paths, no symlinks).")
+	f.Var(&g.fetcherFDs, "fetcher-fds", "list of FDs connected to the process that fetches the files of the lazily loaded root image")
 }
 
 // Execute implements subcommands.Command.
@@ -129,6 +140,18 @@ func (g *Gofer) Execute(_ context.Context, f *flag.FlagSet, args ...interface{})
 		root = "/root"
 	}
 
+	// Open the image of the root filesystem if it is lazily loaded, while its
+	// cache directory is still accessible.
+	var img *lazyimage.Image
+	if ref, ok := specutils.RootfsLazyImage(spec); ok {
+		if len(g.fetcherFDs) == 0 {
+			Fatalf("no FD found for the fetcher of image %q. Did you forget --fetcher-fds?", ref)
+		}
+		if img, err = g.openLazyImage(conf, ref); err != nil {
+			Fatalf("opening image %q: %v", ref, err)
+		}
+	}
+
 	// Resolve mount points paths, then replace mounts from our spec and send the
 	// mount list over to the sandbox, so they are both in sync.
 	//
@@ -165,21 +188,34 @@ func (g *Gofer) Execute(_ context.Context, f *flag.FlagSet, args ...interface{})
 
 	// Start with root mount, then add any other additional mount as needed.
 	ats := make([]p9.Attacher, 0, len(spec.Mounts)+1)
-	ap, err := fsgofer.NewAttachPoint("/", fsgofer.Config{
-		ROMount: spec.Root.Readonly || conf.Overlay,
-	})
-	if err != nil {
-		Fatalf("creating attach point: %v", err)
+	if img != nil {
+		ats = append(ats, lazyimage.NewAttachPoint(img))
+		log.Infof("Serving %q from image %q on FD %d", "/", spec.Annotations[specutils.RootfsSourceAnnotation], g.ioFDs[0])
+	} else {
+		ap, err := fsgofer.NewAttachPoint("/", fsgofer.Config{
+			ROMount:       spec.Root.Readonly || conf.Overlay,
+			Invalidations: conf.FSGoferInvalidations,
+		})
+		if err != nil {
+			Fatalf("creating attach point: %v", err)
+		}
+		ats = append(ats, ap)
+		log.Infof("Serving %q mapped to %q on FD %d (ro: %t)", "/", root, g.ioFDs[0], spec.Root.Readonly)
 	}
-	ats = append(ats, ap)
-	log.Infof("Serving %q mapped to %q on FD %d (ro: %t)", "/", root, g.ioFDs[0], spec.Root.Readonly)
 
 	mountIdx := 1 // first one is the root
+	hostUDS := conf.FSGoferHostUDS
 	for _, m := range spec.Mounts {
 		if specutils.Is9PMount(m) {
+			// The systemd notification socket is a host UDS that the
+			// container must be able to connect to.
+			notify := specutils.IsNotifySocketMount(conf.RootDir, m)
+			hostUDS = hostUDS || notify
 			cfg := fsgofer.Config{
-				ROMount: isReadonlyMount(m.Options) || conf.Overlay,
-				HostUDS: conf.FSGoferHostUDS,
+				ROMount:       isReadonlyMount(m.Options) || conf.Overlay,
+				HostUDS:       conf.FSGoferHostUDS || notify,
+				HostUDSCreate: conf.FSGoferHostUDSCreate,
+				Invalidations: conf.FSGoferInvalidations,
 			}
 			ap, err := fsgofer.NewAttachPoint(m.Destination, cfg)
 			if err != nil {
@@ -198,19 +234,49 @@ func (g *Gofer) Execute(_ context.Context, f *flag.FlagSet, args ...interface{})
 		Fatalf("too many FDs passed for mounts. mounts: %d, FDs: %d", mountIdx, len(g.ioFDs))
 	}
 
-	if conf.FSGoferHostUDS {
+	if hostUDS {
 		filter.InstallUDSFilters()
 	}
+	if conf.FSGoferHostUDSCreate {
+		filter.InstallUDSCreateFilters()
+	}
+	if conf.FSGoferInvalidations {
+		filter.InstallInvalidationsFilters()
+	}
 
 	if err := filter.Install(); err != nil {
 		Fatalf("installing seccomp filters: %v", err)
 	}
 
-	runServers(ats, g.ioFDs)
+	if img != nil {
+		go img.Prefetch()
+	}
+
+	runServers(ats, g.ioFDs, p9.ServerOpts{Channels: conf.GoferChannels})
 	return subcommands.ExitSuccess
 }
 
-func runServers(ats []p9.Attacher, ioFDs []int) {
+// openLazyImage opens the image with the given reference. It must be called
+// before the gofer changes its root to the container's root.
+//
+// The gofer has no network access: requests to the image's registry are sent
+// by the fetcher, over the connections in g.fetcherFDs.
+func (g *Gofer) openLazyImage(conf *config.Config, ref string) (*lazyimage.Image, error) {
+	conns := make([]io.ReadWriteCloser, 0, len(g.fetcherFDs))
+	for _, fd := range g.fetcherFDs {
+		conns = append(conns, os.NewFile(uintptr(fd), "fetcher connection"))
+	}
+	opts := lazyimage.Options{
+		CacheDir:  conf.LazyImageCache,
+		Transport: lazyimage.NewFetcherTransport(conns),
+	}
+	if opts.CacheDir != "" && !conf.TestOnlyAllowRunAsCurrentUserWithoutChroot {
+		opts.CacheDir = lazyImageCacheDir
+	}
+	return lazyimage.Open(context.Background(), ref, opts)
+}
+
+func runServers(ats []p9.Attacher, ioFDs []int, opts p9.ServerOpts) {
 	// Run the loops and wait for all to exit.
 	var wg sync.WaitGroup
 	for i, ioFD := range ioFDs {
@@ -220,7 +286,7 @@ func runServers(ats []p9.Attacher, ioFDs []int) {
 			if err != nil {
 				Fatalf("creating server on FD %d: %v", ioFD, err)
 			}
-			s := p9.NewServer(at)
+			s := p9.NewServerWithOpts(at, opts)
 			if err := s.Handle(socket); err != nil {
 				Fatalf("P9 server returned error. Gofer is shutting down. FD: %d, err: %v", ioFD, err)
 			}
@@ -286,6 +352,12 @@ func setupRootFS(spec *specs.Spec, conf *config.Config) error {
 			Fatalf("error mounting proc: %v", err)
 		}
 		root = "/proc/root"
+
+		if _, ok := specutils.RootfsLazyImage(spec); ok && conf.LazyImageCache != "" {
+			if err := setupLazyImageCache(conf, "/proc"); err != nil {
+				Fatalf("error setting up the cache of the root image: %v", err)
+			}
+		}
 	}
 
 	// Mount root path followed by submounts.
@@ -340,6 +412,22 @@ func setupRootFS(spec *specs.Spec, conf *config.Config) error {
 	return nil
 }
 
+// setupLazyImageCache makes the directory of the image cache accessible in
+// newRoot, which becomes the gofer's root.
+func setupLazyImageCache(conf *config.Config, newRoot string) error {
+	if err := os.MkdirAll(conf.LazyImageCache, 0700); err != nil {
+		return fmt.Errorf("creating %q: %v", conf.LazyImageCache, err)
+	}
+	dst := filepath.Join(newRoot, lazyImageCacheDir)
+	if err := os.Mkdir(dst, 0700); err != nil {
+		return err
+	}
+	if err := syscall.Mount(conf.LazyImageCache, dst, "bind", syscall.MS_BIND, ""); err != nil {
+		return fmt.Errorf("mounting %q: %v", conf.LazyImageCache, err)
+	}
+	return nil
+}
+
 // setupMounts binds mount all mounts specified in the spec in their correct
 // location inside root. It will resolve relative paths and symlinks. It also
 // creates directories as needed.
@@ -0,0 +1,222 @@
+// Copyright 2020 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"time"
+
+	"github.com/google/subcommands"
+	"gvisor.dev/gvisor/pkg/log"
+	"gvisor.dev/gvisor/runsc/config"
+	"gvisor.dev/gvisor/runsc/container"
+	"gvisor.dev/gvisor/runsc/flag"
+)
+
+// Migrate implements subcommands.Command for the "migrate" command.
+type Migrate struct {
+	to        string
+	maxPause  time.Duration
+	maxRounds int
+	tls       migrationTLS
+}
+
+// Name implements subcommands.Command.Name.
+func (*Migrate) Name() string {
+	return "migrate"
+}
+
+// Synopsis implements subcommands.Command.Synopsis.
+func (*Migrate) Synopsis() string {
+	return "live-migrate a container to another host (experimental)"
+}
+
+// Usage implements subcommands.Command.Usage.
+func (*Migrate) Usage() string {
+	return `migrate [flags] <container id> - transfer the state of the container's sandbox to "runsc restore --migrate-listen" on another host, while the sandbox runs. The sandbox is paused for about --max-pause at the end, and exits once the destination has its state; if migration fails, it keeps running.
+
+Network connections survive migration only if the sandbox's IP address moves to the destination with it. Requires the KVM platform.
+`
+}
+
+// SetFlags implements subcommands.Command.SetFlags.
+func (m *Migrate) SetFlags(f *flag.FlagSet) {
+	f.StringVar(&m.to, "to", "", "host:port at which the destination is listening")
+	f.DurationVar(&m.maxPause, "max-pause", 100*time.Millisecond, "longest that the sandbox should be paused for the final transfer")
+	f.IntVar(&m.maxRounds, "max-rounds", 0, "maximum number of times memory is copied while the sandbox runs, or 0 for the default")
+	m.tls.setFlags(f)
+}
+
+// Execute implements subcommands.Command.Execute.
+func (m *Migrate) Execute(_ context.Context, f *flag.FlagSet, args ...interface{}) subcommands.ExitStatus {
+	if f.NArg() != 1 {
+		f.Usage()
+		return subcommands.ExitUsageError
+	}
+
+	id := f.Arg(0)
+	conf := args[0].(*config.Config)
+
+	if m.to == "" {
+		Fatalf("to flag must be provided")
+	}
+	if m.maxRounds < 0 {
+		Fatalf("max-rounds must be at least 0")
+	}
+	tlsConf, err := m.tls.config(false /* server */)
+	if err != nil {
+		Fatalf("configuring TLS: %v", err)
+	}
+
+	cont, err := container.Load(conf.RootDir, container.FullID{ContainerID: id}, container.LoadOpts{})
+	if err != nil {
+		Fatalf("loading container: %v", err)
+	}
+
+	var conn net.Conn
+	if tlsConf != nil {
+		conn, err = tls.Dial("tcp", m.to, tlsConf)
+	} else {
+		log.Warningf("Migrating without TLS; the sandbox's state is sent in the clear")
+		conn, err = net.Dial("tcp", m.to)
+	}
+	if err != nil {
+		Fatalf("connecting to %q: %v", m.to, err)
+	}
+	defer conn.Close()
+
+	// The sandbox can't make network connections, so it writes to a pipe
+	// which is copied to the connection.
+	pr, pw, err := os.Pipe()
+	if err != nil {
+		Fatalf("creating pipe: %v", err)
+	}
+	copied := make(chan error, 1)
+	go func() {
+		_, err := io.Copy(conn, pr)
+		pr.Close()
+		copied <- err
+	}()
+
+	err = cont.Migrate(pw, m.maxPause, m.maxRounds)
+	pw.Close()
+	if copyErr := <-copied; err == nil && copyErr != nil {
+		err = fmt.Errorf("sending state to %q: %v", m.to, copyErr)
+	}
+	if err != nil {
+		Fatalf("migration failed: %v", err)
+	}
+	log.Infof("Migrated container %q to %q", id, m.to)
+	return subcommands.ExitSuccess
+}
+
+// migrationTLS holds the TLS flags shared by the source and destination of a
+// migration.
+type migrationTLS struct {
+	cert string
+	key  string
+	ca   string
+}
+
+func (t *migrationTLS) setFlags(f *flag.FlagSet) {
+	f.StringVar(&t.cert, "tls-cert", "", "PEM certificate to present to the other host; required by the destination to enable TLS")
+	f.StringVar(&t.key, "tls-key", "", "PEM private key of --tls-cert")
+	f.StringVar(&t.ca, "tls-ca", "", "PEM CA certificates that must sign the other host's certificate; required by the source to enable TLS")
+}
+
+// config returns the TLS configuration for the migration connection, or nil
+// if TLS isn't enabled. If CA certificates are given, the peer must present a
+// certificate signed by one of them.
+func (t *migrationTLS) config(server bool) (*tls.Config, error) {
+	if t.cert == "" && t.key == "" && t.ca == "" {
+		return nil, nil
+	}
+	conf := &tls.Config{MinVersion: tls.VersionTLS12}
+	if t.cert != "" || t.key != "" {
+		cert, err := tls.LoadX509KeyPair(t.cert, t.key)
+		if err != nil {
+			return nil, err
+		}
+		conf.Certificates = []tls.Certificate{cert}
+	} else if server {
+		return nil, fmt.Errorf("tls-cert and tls-key must be provided to accept TLS connections")
+	}
+	if t.ca != "" {
+		pem, err := ioutil.ReadFile(t.ca)
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in %q", t.ca)
+		}
+		if server {
+			conf.ClientCAs = pool
+			conf.ClientAuth = tls.RequireAndVerifyClientCert
+		} else {
+			conf.RootCAs = pool
+		}
+	}
+	return conf, nil
+}
+
+// acceptMigration waits for a single migration connection at addr, and returns
+// a pipe from which the migration stream can be read. The pipe is closed when
+// the connection is.
+func acceptMigration(addr string, tlsConf *tls.Config) (*os.File, error) {
+	var (
+		l   net.Listener
+		err error
+	)
+	if tlsConf != nil {
+		l, err = tls.Listen("tcp", addr, tlsConf)
+	} else {
+		log.Warningf("Accepting migration without TLS; the sandbox's state is received in the clear")
+		l, err = net.Listen("tcp", addr)
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer l.Close()
+	log.Infof("Waiting for migration at %q", l.Addr())
+	conn, err := l.Accept()
+	if err != nil {
+		return nil, err
+	}
+	log.Infof("Receiving migration from %q", conn.RemoteAddr())
+
+	// As for Migrate, the sandbox reads from a pipe rather than from the
+	// connection.
+	pr, pw, err := os.Pipe()
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	go func() {
+		if _, err := io.Copy(pw, conn); err != nil {
+			log.Warningf("Receiving migration from %q: %v", conn.RemoteAddr(), err)
+		}
+		conn.Close()
+		pw.Close()
+	}()
+	return pr, nil
+}
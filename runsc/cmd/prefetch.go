@@ -0,0 +1,107 @@
+// Copyright 2020 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/google/subcommands"
+	"gvisor.dev/gvisor/pkg/log"
+	"gvisor.dev/gvisor/runsc/config"
+	"gvisor.dev/gvisor/runsc/container"
+	"gvisor.dev/gvisor/runsc/flag"
+)
+
+// Prefetch implements subcommands.Command for the "prefetch" command.
+type Prefetch struct {
+	listFile string
+}
+
+// Name implements subcommands.Command.Name.
+func (*Prefetch) Name() string {
+	return "prefetch"
+}
+
+// Synopsis implements subcommands.Command.Synopsis.
+func (*Prefetch) Synopsis() string {
+	return "read files in a container ahead of their use"
+}
+
+// Usage implements subcommands.Command.Usage.
+func (*Prefetch) Usage() string {
+	return `prefetch [flags] <container id> [path...] - read the files at the given paths in the container, recursing into directories, so that their contents are cached by the time the container's applications read them.
+`
+}
+
+// SetFlags implements subcommands.Command.SetFlags.
+func (p *Prefetch) SetFlags(f *flag.FlagSet) {
+	f.StringVar(&p.listFile, "list", "", "file containing additional paths to read, one per line, or - to read them from stdin")
+}
+
+// Execute implements subcommands.Command.Execute.
+func (p *Prefetch) Execute(_ context.Context, f *flag.FlagSet, args ...interface{}) subcommands.ExitStatus {
+	if f.NArg() < 1 {
+		f.Usage()
+		return subcommands.ExitUsageError
+	}
+
+	id := f.Arg(0)
+	conf := args[0].(*config.Config)
+	paths := f.Args()[1:]
+	if p.listFile != "" {
+		listed, err := readPathList(p.listFile)
+		if err != nil {
+			Fatalf("reading path list %q: %v", p.listFile, err)
+		}
+		paths = append(paths, listed...)
+	}
+
+	c, err := container.Load(conf.RootDir, container.FullID{ContainerID: id}, container.LoadOpts{})
+	if err != nil {
+		Fatalf("loading container: %v", err)
+	}
+	files, err := c.Prefetch(paths)
+	if err != nil {
+		Fatalf("prefetch failed: %v", err)
+	}
+	log.Infof("Prefetched %d files in container %q", files, id)
+	return subcommands.ExitSuccess
+}
+
+// readPathList returns the non-empty lines of the file at the given path, or
+// of stdin if path is "-".
+func readPathList(path string) ([]string, error) {
+	var r io.Reader = os.Stdin
+	if path != "-" {
+		f, err := os.Open(path)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		r = f
+	}
+	var paths []string
+	s := bufio.NewScanner(r)
+	for s.Scan() {
+		if line := strings.TrimSpace(s.Text()); line != "" {
+			paths = append(paths, line)
+		}
+	}
+	return paths, s.Err()
+}
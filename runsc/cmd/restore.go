@@ -16,10 +16,14 @@ package cmd
 
 import (
 	"context"
+	"fmt"
+	"io"
+	"os"
 	"path/filepath"
 	"syscall"
 
 	"github.com/google/subcommands"
+	"gvisor.dev/gvisor/pkg/log"
 	"gvisor.dev/gvisor/runsc/config"
 	"gvisor.dev/gvisor/runsc/container"
 	"gvisor.dev/gvisor/runsc/flag"
@@ -34,8 +38,20 @@ type Restore struct {
 	// imagePath is the path to the saved container image
 	imagePath string
 
+	// imageFD is a file descriptor from which the container image is read
+	// sequentially, or -1.
+	imageFD int
+
 	// detach indicates that runsc has to start a process and exit without waiting it.
 	detach bool
+
+	// migrateListen is the address at which to wait for a live migration
+	// from "runsc migrate", which replaces the saved container image.
+	migrateListen string
+
+	tls migrationTLS
+
+	encryption stateEncryption
 }
 
 // Name implements subcommands.Command.Name.
@@ -51,6 +67,10 @@ func (*Restore) Synopsis() string {
 // Usage implements subcommands.Command.Usage.
 func (*Restore) Usage() string {
 	return `restore [flags] <container id> - restore saved state of container.
+
+With --image-fd, the image is read sequentially from the given file descriptor, which may be a pipe or socket, e.g. one written by "runsc checkpoint --image-fd". Such images aren't verified against a manifest before they are restored, but their integrity is still checked while they are read.
+
+If the image was encrypted, the key must be given with --encryption-key-file or --encryption-key-command. The image is verified against the manifest written alongside it before it is restored.
 `
 }
 
@@ -58,7 +78,11 @@ func (*Restore) Usage() string {
 func (r *Restore) SetFlags(f *flag.FlagSet) {
 	r.Create.SetFlags(f)
 	f.StringVar(&r.imagePath, "image-path", "", "directory path to saved container image")
+	f.IntVar(&r.imageFD, "image-fd", -1, "file descriptor, such as a pipe or socket, from which the container image is streamed instead of image-path")
 	f.BoolVar(&r.detach, "detach", false, "detach from the container's process")
+	f.StringVar(&r.migrateListen, "migrate-listen", "", "host:port at which to receive the container's state from \"runsc migrate\", instead of restoring from image-path")
+	r.tls.setFlags(f)
+	r.encryption.setFlags(f)
 
 	// Unimplemented flags necessary for compatibility with docker.
 
@@ -94,12 +118,51 @@ func (r *Restore) Execute(_ context.Context, f *flag.FlagSet, args ...interface{
 	}
 	specutils.LogSpec(spec)
 
-	if r.imagePath == "" {
-		return Errorf("image-path flag must be provided")
+	switch {
+	case r.migrateListen != "":
+		if r.imagePath != "" || r.imageFD >= 0 {
+			return Errorf("migrate-listen can't be used with image-path or image-fd")
+		}
+		tlsConf, err := r.tls.config(true /* server */)
+		if err != nil {
+			return Errorf("configuring TLS: %v", err)
+		}
+		stream, err := acceptMigration(r.migrateListen, tlsConf)
+		if err != nil {
+			return Errorf("accepting migration: %v", err)
+		}
+		defer stream.Close()
+		conf.RestoreFile = fmt.Sprintf("/proc/self/fd/%d", stream.Fd())
+		conf.RestoreMigration = true
+	case r.imageFD >= 0:
+		if r.imagePath != "" {
+			return Errorf("image-path and image-fd flags are mutually exclusive")
+		}
+		key, err := r.encryption.key()
+		if err != nil {
+			return Errorf("getting encryption key: %v", err)
+		}
+		stream, err := imageStream(os.NewFile(uintptr(r.imageFD), "checkpoint image"))
+		if err != nil {
+			return Errorf("reading checkpoint image: %v", err)
+		}
+		defer stream.Close()
+		conf.RestoreFile = fmt.Sprintf("/proc/self/fd/%d", stream.Fd())
+		conf.RestoreKey = key
+	case r.imagePath == "":
+		return Errorf("image-path or image-fd flag must be provided")
+	default:
+		key, err := r.encryption.key()
+		if err != nil {
+			return Errorf("getting encryption key: %v", err)
+		}
+		if err := verifyManifest(r.imagePath, key); err != nil {
+			return Errorf("verifying checkpoint image: %v", err)
+		}
+		conf.RestoreFile = filepath.Join(r.imagePath, checkpointFileName)
+		conf.RestoreKey = key
 	}
 
-	conf.RestoreFile = filepath.Join(r.imagePath, checkpointFileName)
-
 	runArgs := container.Args{
 		ID:            id,
 		Spec:          spec,
@@ -117,3 +180,29 @@ func (r *Restore) Execute(_ context.Context, f *flag.FlagSet, args ...interface{
 
 	return subcommands.ExitSuccess
 }
+
+// imageStream returns a file from which the checkpoint image in f can be read
+// by the sandbox, which opens it by path. Pipes and regular files can be
+// reopened through /proc/self/fd, but sockets can't, so the contents of other
+// files are relayed through a pipe.
+func imageStream(f *os.File) (*os.File, error) {
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	if mode := info.Mode(); mode.IsRegular() || mode&os.ModeNamedPipe != 0 {
+		return f, nil
+	}
+	pr, pw, err := os.Pipe()
+	if err != nil {
+		return nil, err
+	}
+	go func() {
+		if _, err := io.Copy(pw, f); err != nil {
+			log.Warningf("Reading checkpoint image: %v", err)
+		}
+		f.Close()
+		pw.Close()
+	}()
+	return pr, nil
+}
@@ -0,0 +1,78 @@
+// Copyright 2021 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"io/ioutil"
+	"os"
+	"syscall"
+	"testing"
+)
+
+func TestImageStreamPipe(t *testing.T) {
+	pr, pw, err := os.Pipe()
+	if err != nil {
+		t.Fatalf("os.Pipe failed: %v", err)
+	}
+	defer pr.Close()
+	defer pw.Close()
+
+	// Pipes can be reopened by path, so they are used directly.
+	f, err := imageStream(pr)
+	if err != nil {
+		t.Fatalf("imageStream failed: %v", err)
+	}
+	if f != pr {
+		t.Errorf("imageStream got %q, want the pipe itself", f.Name())
+	}
+}
+
+func TestImageStreamSocket(t *testing.T) {
+	fds, err := syscall.Socketpair(syscall.AF_UNIX, syscall.SOCK_STREAM|syscall.SOCK_CLOEXEC, 0)
+	if err != nil {
+		t.Fatalf("Socketpair failed: %v", err)
+	}
+	sock := os.NewFile(uintptr(fds[0]), "image socket")
+	peer := os.NewFile(uintptr(fds[1]), "image socket peer")
+	defer peer.Close()
+
+	// Sockets can't be reopened by path, so they are relayed through a
+	// pipe, which is closed when the socket is.
+	f, err := imageStream(sock)
+	if err != nil {
+		t.Fatalf("imageStream failed: %v", err)
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		t.Fatalf("Stat failed: %v", err)
+	}
+	if info.Mode()&os.ModeNamedPipe == 0 {
+		t.Fatalf("imageStream got mode %v, want a pipe", info.Mode())
+	}
+
+	const image = "checkpoint image"
+	if _, err := peer.Write([]byte(image)); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+	peer.Close()
+	got, err := ioutil.ReadAll(f)
+	if err != nil {
+		t.Fatalf("ReadAll failed: %v", err)
+	}
+	if string(got) != image {
+		t.Errorf("imageStream got %q, want %q", got, image)
+	}
+}
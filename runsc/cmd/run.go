@@ -16,13 +16,16 @@ package cmd
 
 import (
 	"context"
+	"os"
 	"syscall"
 
 	"github.com/google/subcommands"
+	"gvisor.dev/gvisor/pkg/log"
 	"gvisor.dev/gvisor/runsc/config"
 	"gvisor.dev/gvisor/runsc/container"
 	"gvisor.dev/gvisor/runsc/flag"
 	"gvisor.dev/gvisor/runsc/specutils"
+	"gvisor.dev/gvisor/runsc/systemd"
 )
 
 // Run implements subcommands.Command for the "run" command.
@@ -81,6 +84,27 @@ func (r *Run) Execute(_ context.Context, f *flag.FlagSet, args ...interface{}) s
 	}
 	specutils.LogSpec(spec)
 
+	listenFiles, listenNames, err := systemd.ListenFiles()
+	if err != nil {
+		return Errorf("reading socket activation files: %v", err)
+	}
+	specutils.AddListenFDs(spec, len(listenFiles), listenNames)
+
+	if target := os.Getenv("NOTIFY_SOCKET"); target != "" {
+		if r.detach {
+			// Nothing would be left to forward the notifications.
+			log.Warningf("NOTIFY_SOCKET is ignored with --detach")
+		} else {
+			proxy, err := systemd.NewNotifyProxy(conf.RootDir, target)
+			if err != nil {
+				return Errorf("creating notification proxy: %v", err)
+			}
+			defer proxy.Close()
+			specutils.AddNotifySocket(spec, proxy.Dir(), systemd.WatchdogUSec())
+			go proxy.Serve()
+		}
+	}
+
 	runArgs := container.Args{
 		ID:            id,
 		Spec:          spec,
@@ -89,6 +113,7 @@ func (r *Run) Execute(_ context.Context, f *flag.FlagSet, args ...interface{}) s
 		PIDFile:       r.pidFile,
 		UserLog:       r.userLog,
 		Attached:      !r.detach,
+		ListenFiles:   listenFiles,
 	}
 	ws, err := container.Run(conf, runArgs)
 	if err != nil {
@@ -0,0 +1,118 @@
+// Copyright 2021 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+
+	"github.com/google/subcommands"
+	specs "github.com/opencontainers/runtime-spec/specs-go"
+	"gvisor.dev/gvisor/pkg/log"
+	"gvisor.dev/gvisor/runsc/config"
+	"gvisor.dev/gvisor/runsc/container"
+	"gvisor.dev/gvisor/runsc/flag"
+)
+
+// Update implements subcommands.Command for the "update" command.
+type Update struct {
+	cpus      uint
+	memory    uint64
+	resources string
+}
+
+// Name implements subcommands.Command.Name.
+func (*Update) Name() string {
+	return "update"
+}
+
+// Synopsis implements subcommands.Command.Synopsis.
+func (*Update) Synopsis() string {
+	return "change the CPUs and memory visible to a container's sandbox"
+}
+
+// Usage implements subcommands.Command.Usage.
+func (*Update) Usage() string {
+	return `update [flags] <container id> - change the number of CPUs and the amount of memory visible to applications in the container's sandbox.
+
+The number of CPUs can't exceed the --max-cpus that the sandbox was started
+with. The host's resource limits, such as the sandbox's cgroups, are not
+changed.
+
+With --resources, the container's resources are updated as by "runc update",
+e.g. when the Kubelet resizes a pod in place. The sandbox's cgroups are updated
+if the container is the root container. The memory visible to applications
+becomes the sum of the memory limits of the sandbox's containers, and so does
+the number of CPUs with --cpu-num-from-quota, up to the maximum number of CPUs.
+`
+}
+
+// SetFlags implements subcommands.Command.SetFlags.
+func (u *Update) SetFlags(f *flag.FlagSet) {
+	f.UintVar(&u.cpus, "cpus", 0, "number of online CPUs; 0 leaves it unchanged")
+	f.Uint64Var(&u.memory, "memory", 0, "total memory in bytes; 0 leaves it unchanged")
+	f.StringVar(&u.resources, "resources", "", "path to a file with the container's resources, in the format of the OCI spec's linux.resources, or - for stdin")
+}
+
+// Execute implements subcommands.Command.Execute.
+func (u *Update) Execute(_ context.Context, f *flag.FlagSet, args ...interface{}) subcommands.ExitStatus {
+	if f.NArg() != 1 {
+		f.Usage()
+		return subcommands.ExitUsageError
+	}
+
+	id := f.Arg(0)
+	conf := args[0].(*config.Config)
+
+	if u.resources != "" {
+		if u.cpus != 0 || u.memory != 0 {
+			Fatalf("--resources can't be used with --cpus or --memory")
+		}
+	} else if u.cpus == 0 && u.memory == 0 {
+		Fatalf("at least one of --cpus, --memory or --resources must be given")
+	}
+	c, err := container.Load(conf.RootDir, container.FullID{ContainerID: id}, container.LoadOpts{})
+	if err != nil {
+		Fatalf("loading container: %v", err)
+	}
+
+	if u.resources != "" {
+		var data []byte
+		if u.resources == "-" {
+			data, err = ioutil.ReadAll(os.Stdin)
+		} else {
+			data, err = ioutil.ReadFile(u.resources)
+		}
+		if err != nil {
+			Fatalf("reading resources: %v", err)
+		}
+		var res specs.LinuxResources
+		if err := json.Unmarshal(data, &res); err != nil {
+			Fatalf("parsing resources: %v", err)
+		}
+		if err := c.Update(conf, &res); err != nil {
+			Fatalf("updating container: %v", err)
+		}
+		log.Infof("Updated resources of container %q", id)
+		return subcommands.ExitSuccess
+	}
+	if err := c.Resize(u.cpus, u.memory); err != nil {
+		Fatalf("updating container: %v", err)
+	}
+	log.Infof("Updated container %q: cpus: %d, memory: %d", id, u.cpus, u.memory)
+	return subcommands.ExitSuccess
+}
@@ -19,6 +19,7 @@ package config
 
 import (
 	"fmt"
+	"strings"
 
 	"gvisor.dev/gvisor/pkg/refs"
 	"gvisor.dev/gvisor/pkg/sentry/watchdog"
@@ -58,15 +59,94 @@ type Config struct {
 	// DebugLogFormat is the log format for debug.
 	DebugLogFormat string `flag:"debug-log-format"`
 
+	// OTLPTraceFile is the path to which OpenTelemetry spans are written as
+	// OTLP/JSON, if not empty. It accepts the same variables as DebugLog.
+	OTLPTraceFile string `flag:"otlp-trace-file"`
+
+	// OTLPSyscallThresholdUS, if non-zero, causes spans to be exported for
+	// syscalls that take at least this many microseconds.
+	OTLPSyscallThresholdUS uint `flag:"otlp-syscall-threshold-us"`
+
+	// OTLPGoferRPCs causes spans to be exported for gofer RPCs.
+	OTLPGoferRPCs bool `flag:"otlp-gofer-rpcs"`
+
 	// FileAccess indicates how the filesystem is accessed.
 	FileAccess FileAccessType `flag:"file-access"`
 
 	// Overlay is whether to wrap the root filesystem in an overlay.
 	Overlay bool `flag:"overlay"`
 
+	// OverlayUpper is the path of a host file in which the upper layer of the
+	// root container's root overlay is persisted, if not empty. If the path
+	// is a directory, the file is created in it and named after the
+	// container. While the sandbox runs, the data of files in the upper layer
+	// is spilled to a file in TmpfsSpillDir, or in the directory of the
+	// persisted file if TmpfsSpillDir is empty.
+	OverlayUpper string `flag:"overlay-upper"`
+
+	// TmpfsSpillDir is a host directory in which a file is created to store
+	// the data of tmpfs mounts with the spill_threshold option, if not empty.
+	TmpfsSpillDir string `flag:"tmpfs-spill-dir"`
+
+	// GoferReadCache is the path of a host file in which the contents of files
+	// read from gofer mounts that the sandbox doesn't write to are cached, if
+	// not empty. The file may be shared by sandboxes on the same host.
+	GoferReadCache string `flag:"gofer-read-cache"`
+
+	// GoferReadCacheSize is the size of the GoferReadCache file in bytes.
+	GoferReadCacheSize uint `flag:"gofer-read-cache-size"`
+
 	// FSGoferHostUDS enables the gofer to mount a host UDS.
 	FSGoferHostUDS bool `flag:"fsgofer-host-uds"`
 
+	// FSGoferHostUDSCreate makes binding a stream or seqpacket Unix domain
+	// socket to a path on a gofer mount create a socket file on the host, so
+	// that host processes can connect to the sandbox. It requires
+	// FSGoferHostUDS.
+	FSGoferHostUDSCreate bool `flag:"fsgofer-host-uds-create"`
+
+	// HostUDSSCMRights is the comma-separated list of file types that may be
+	// passed in SCM_RIGHTS messages through host UDSes: "file", "dir",
+	// "pipe", "socket", "chardev", "blockdev" and "other", or "all" or
+	// "none".
+	HostUDSSCMRights string `flag:"host-uds-scm-rights"`
+
+	// FSGoferInvalidations enables the gofer to report changes made to
+	// files, letting the sandbox cache the metadata of shared files.
+	FSGoferInvalidations bool `flag:"fsgofer-invalidations"`
+
+	// GoferRemoteLocks makes the sandbox take POSIX record locks and flock(2)
+	// locks on host files through the gofer for all mounts, so that they are
+	// coherent with the locks taken by host processes and other sandboxes.
+	// Mounts with shared file access always do so.
+	GoferRemoteLocks bool `flag:"gofer-remote-locks"`
+
+	// GoferChannels is the number of channels used to send requests to the
+	// gofer concurrently for each mount. 0 selects a default based on the
+	// number of CPUs.
+	GoferChannels int `flag:"gofer-channels"`
+
+	// GoferDirtyBytes enables write-back caching of files for which the gofer
+	// doesn't donate host FDs, with at most GoferDirtyBytes of dirty data for
+	// each mount before writers write it back. 0 disables write-back caching.
+	// It only applies to mounts with exclusive file access.
+	GoferDirtyBytes uint `flag:"gofer-dirty-bytes"`
+
+	// GoferDirtyBackgroundBytes is the amount of dirty data for each mount
+	// above which it is written back in the background. 0 selects half of
+	// GoferDirtyBytes.
+	GoferDirtyBackgroundBytes uint `flag:"gofer-dirty-background-bytes"`
+
+	// GoferReadahead is the number of bytes read into the page cache at a
+	// time from files of gofer mounts, unless more are required. 0 selects
+	// the default.
+	GoferReadahead uint `flag:"gofer-readahead"`
+
+	// LazyImageCache is the directory where the gofer caches the chunks of
+	// files that it fetches from registries for root filesystems that are
+	// lazily loaded images. If empty, chunks are only cached in memory.
+	LazyImageCache string `flag:"lazy-image-cache"`
+
 	// Network indicates what type of network to use.
 	Network NetworkType `flag:"network"`
 
@@ -91,12 +171,72 @@ type Config struct {
 	// for non-loopback interfaces.
 	QDisc QueueingDiscipline `flag:"qdisc"`
 
+	// TCPCheckpoint allows established TCP connections on non-loopback
+	// interfaces to be saved by checkpoint and resumed on restore.
+	TCPCheckpoint bool `flag:"tcp-checkpoint"`
+
+	// TCPRestoreWindowSec, if non-zero, causes TCP connections that were
+	// suspended by checkpoint for longer than this many seconds to be reset
+	// on restore.
+	TCPRestoreWindowSec uint `flag:"tcp-restore-window-sec"`
+
+	// DNSStub enables a caching DNS stub resolver listening on 127.0.0.53 in
+	// the sandbox network stack.
+	DNSStub bool `flag:"dns-stub"`
+
+	// DNSUpstreams is the comma-separated list of DNS servers the stub
+	// resolver forwards queries to by default.
+	DNSUpstreams string `flag:"dns-upstreams"`
+
+	// DNSForward is the comma-separated list of domain=server conditional
+	// forwarding rules of the stub resolver.
+	DNSForward string `flag:"dns-forward"`
+
+	// DNSCacheSize is the maximum number of responses cached by the stub
+	// resolver.
+	DNSCacheSize int `flag:"dns-cache-size"`
+
 	// LogPackets indicates that all network packets should be logged.
 	LogPackets bool `flag:"log-packets"`
 
 	// Platform is the platform to run on.
 	Platform string `flag:"platform"`
 
+	// IdleReclaimSec, if non-zero, causes memory to be returned to the host
+	// after application code hasn't run for this many seconds. Only the KVM
+	// platform supports it.
+	IdleReclaimSec uint `flag:"idle-reclaim-sec"`
+
+	// AttestationDevice exposes the attestation device of the confidential
+	// computing guest (AMD SEV-SNP or Intel TDX) that runsc runs in to the
+	// sandbox, so that applications can obtain attestation reports.
+	AttestationDevice bool `flag:"attestation-device"`
+
+	// ROCm exposes the host's AMD ROCm compute device, /dev/kfd, to the
+	// sandbox.
+	ROCm bool `flag:"rocm"`
+
+	// CDISpecDirs is a comma-separated list of directories holding Container
+	// Device Interface specs, which describe the devices that containers
+	// request with cdi.k8s.io/ annotations. Empty disables CDI.
+	CDISpecDirs string `flag:"cdi-spec-dirs"`
+
+	// MaxCPUs is the number of CPUs that the sandbox can be resized to by
+	// "runsc update". If it is not greater than the initial number of CPUs,
+	// the number of CPUs can only be decreased.
+	MaxCPUs int `flag:"max-cpus"`
+
+	// CPUFeatures, if not empty, is a comma-separated list of the CPU
+	// features, named as in /proc/cpuinfo, that applications may use. Host
+	// features not in the list are hidden, so that a checkpoint can be
+	// restored on any host that has all of the listed features.
+	CPUFeatures string `flag:"cpu-features"`
+
+	// Hugepages controls whether sandbox memory is backed by host hugepages.
+	// It may be "none", "thp" (transparent hugepages), "2m" or "1g"
+	// (hugetlbfs pages, which must be reserved on the host).
+	Hugepages string `flag:"hugepages"`
+
 	// Strace indicates that strace should be enabled.
 	Strace bool `flag:"strace"`
 
@@ -108,6 +248,19 @@ type Config struct {
 	// StraceLogSize is the max size of data blobs to display.
 	StraceLogSize uint `flag:"strace-log-size"`
 
+	// StraceFormat is the format of straces: text or json.
+	StraceFormat string `flag:"strace-format"`
+
+	// StraceRateLimit is a comma-separated list of <syscall>:<rate> limits,
+	// in syscalls traced per second. A syscall of "*" applies to all
+	// syscalls without their own limit.
+	StraceRateLimit string `flag:"strace-rate-limit"`
+
+	// StraceRedact is a comma-separated list of syscall arguments to redact
+	// from straces, as <syscall>:<argument index>, <syscall> for all of its
+	// arguments, or "buffers" for the data buffers of all syscalls.
+	StraceRedact string `flag:"strace-redact"`
+
 	// DisableSeccomp indicates whether seccomp syscall filters should be
 	// disabled. Pardon the double negation, but default to enabled is important.
 	DisableSeccomp bool
@@ -115,6 +268,20 @@ type Config struct {
 	// WatchdogAction sets what action the watchdog takes when triggered.
 	WatchdogAction watchdog.Action `flag:"watchdog-action"`
 
+	// WatchdogTaskTimeoutSec is the number of seconds a task may run in the
+	// sentry without blocking before the watchdog considers it stuck. 0
+	// disables stuck task detection.
+	WatchdogTaskTimeoutSec uint `flag:"watchdog-task-timeout-sec"`
+
+	// WatchdogStartupTimeoutSec is the number of seconds the sandbox may take
+	// to start before the watchdog reports it.
+	WatchdogStartupTimeoutSec uint `flag:"watchdog-startup-timeout-sec"`
+
+	// WatchdogCheckpoint is the path of the host file to which the sandbox is
+	// checkpointed when the watchdog action is checkpoint-then-panic. If it is
+	// a directory, a file named after the sandbox is used.
+	WatchdogCheckpoint string `flag:"watchdog-checkpoint"`
+
 	// PanicSignal registers signal handling that panics. Usually set to
 	// SIGUSR2(12) to troubleshoot hangs. -1 disables it.
 	PanicSignal int `flag:"panic-signal"`
@@ -122,9 +289,41 @@ type Config struct {
 	// ProfileEnable is set to prepare the sandbox to be profiled.
 	ProfileEnable bool `flag:"profile"`
 
+	// ProfileRing is the path of a host file in which recent profiles of the
+	// sandbox are continuously kept, if not empty. If the path is a
+	// directory, the file is created in it and named after the sandbox.
+	ProfileRing string `flag:"profile-ring"`
+
+	// ProfileRingSize is the size of the ProfileRing file in bytes.
+	ProfileRingSize uint `flag:"profile-ring-size"`
+
+	// FSStats enables the collection of per-mount file operation
+	// statistics.
+	FSStats bool `flag:"fs-stats"`
+
+	// FSSlowOpMS, if non-zero, causes file operations that take at least
+	// this many milliseconds to be logged.
+	FSSlowOpMS uint `flag:"fs-slow-op-ms"`
+
+	// SyscallStats enables the collection of per-syscall latency histograms
+	// and of the slowest recent syscalls.
+	SyscallStats bool `flag:"syscall-stats"`
+
+	// CorePattern enables core dumps of crashing processes if not empty. It
+	// is either the pattern of the names of core files in the sandbox, or
+	// "|" followed by a host command that reads core dumps from its stdin.
+	CorePattern string `flag:"core-pattern"`
+
 	// RestoreFile is the path to the saved container image
 	RestoreFile string
 
+	// RestoreMigration is true if RestoreFile is a live migration stream
+	// rather than a checkpoint.
+	RestoreMigration bool
+
+	// RestoreKey is the key with which RestoreFile was encrypted, if any.
+	RestoreKey []byte
+
 	// NumNetworkChannels controls the number of AF_PACKET sockets that map
 	// to the same underlying network device. This allows netstack to better
 	// scale for high throughput use cases.
@@ -136,6 +335,13 @@ type Config struct {
 	// mapped to the caller's user.
 	Rootless bool `flag:"rootless"`
 
+	// Podman enables the behaviors that podman and its conmon monitor expect
+	// from the runtime: the exit status of the sandbox is saved to a file, so
+	// that it's available after conmon reaps the sandbox process, console
+	// sockets are checked like runc does, and cgroups that a rootless user
+	// can't configure are skipped.
+	Podman bool `flag:"podman"`
+
 	// AlsoLogToStderr allows to send log messages to stderr.
 	AlsoLogToStderr bool `flag:"alsologtostderr"`
 
@@ -154,15 +360,31 @@ type Config struct {
 	// E.g. 0.2 CPU quota will result in 1, and 1.9 in 2.
 	CPUNumFromQuota bool `flag:"cpu-num-from-quota"`
 
+	// SystemdCgroup creates the sandbox cgroup as a systemd scope, in which
+	// case cgroups paths have the form "slice:prefix:name". Requires cgroup v2.
+	SystemdCgroup bool `flag:"systemd-cgroup"`
+
+	// CgroupCPUSetPartition makes the sandbox cgroup a cpuset partition of this
+	// type: member, root or isolated. Requires cgroup v2.
+	CgroupCPUSetPartition string `flag:"cgroup-cpuset-partition"`
+
 	// Enables VFS2.
 	VFS2 bool `flag:"vfs2"`
 
 	// Enables FUSE usage.
 	FUSE bool `flag:"fuse"`
 
+	// Cgroupfs enables the emulation of a cgroup v2 filesystem, which
+	// cgroup mounts of the containers use, so that container runtimes can
+	// run in the sandbox. Limits written to it are recorded, not enforced.
+	Cgroupfs bool `flag:"cgroupfs"`
+
 	// Allows overriding of flags in OCI annotations.
 	AllowFlagOverride bool `flag:"allow-flag-override"`
 
+	// Allows overriding of the flags in ContainerFlags in OCI annotations.
+	AllowContainerFlagOverride bool `flag:"allow-container-flag-override"`
+
 	// Enables seccomp inside the sandbox.
 	OCISeccomp bool `flag:"oci-seccomp"`
 
@@ -184,9 +406,97 @@ func (c *Config) validate() error {
 	if c.FileAccess == FileAccessShared && c.Overlay {
 		return fmt.Errorf("overlay flag is incompatible with shared file access")
 	}
+	if c.OverlayUpper != "" {
+		if !c.Overlay {
+			return fmt.Errorf("overlay-upper flag requires overlay")
+		}
+		if !c.VFS2 {
+			return fmt.Errorf("overlay-upper flag requires vfs2")
+		}
+	}
+	if c.Cgroupfs && !c.VFS2 {
+		return fmt.Errorf("cgroupfs flag requires vfs2")
+	}
+	if c.TmpfsSpillDir != "" && !c.VFS2 {
+		return fmt.Errorf("tmpfs-spill-dir flag requires vfs2")
+	}
+	if c.GoferReadCache != "" && !c.VFS2 {
+		return fmt.Errorf("gofer-read-cache flag requires vfs2")
+	}
+	if (c.FSStats || c.FSSlowOpMS != 0) && !c.VFS2 {
+		return fmt.Errorf("fs-stats and fs-slow-op-ms flags require vfs2")
+	}
+	if c.CorePattern != "" && !strings.HasPrefix(c.CorePattern, "|") && !c.VFS2 {
+		return fmt.Errorf("core-pattern flag requires vfs2, unless it is a pipe")
+	}
+	if strings.HasPrefix(c.CorePattern, "|") && len(strings.Fields(c.CorePattern[1:])) == 0 {
+		return fmt.Errorf("core-pattern flag must name a command after |")
+	}
+	if c.WatchdogAction == watchdog.CheckpointAndPanic && c.WatchdogCheckpoint == "" {
+		return fmt.Errorf("watchdog-action=checkpoint-then-panic requires watchdog-checkpoint")
+	}
+	if c.WatchdogCheckpoint != "" && c.WatchdogAction != watchdog.CheckpointAndPanic {
+		return fmt.Errorf("watchdog-checkpoint flag requires watchdog-action=checkpoint-then-panic")
+	}
+	if c.ProfileRing != "" && !c.ProfileEnable {
+		return fmt.Errorf("profile-ring flag requires profile")
+	}
+	switch c.CgroupCPUSetPartition {
+	case "", "member", "root", "isolated":
+	default:
+		return fmt.Errorf("invalid cgroup-cpuset-partition %q, must be member, root or isolated", c.CgroupCPUSetPartition)
+	}
+	if c.StraceFormat != "text" && c.StraceFormat != "json" {
+		return fmt.Errorf("invalid strace-format %q, must be text or json", c.StraceFormat)
+	}
+	if (c.OTLPSyscallThresholdUS != 0 || c.OTLPGoferRPCs) && c.OTLPTraceFile == "" {
+		return fmt.Errorf("otlp-syscall-threshold-us and otlp-gofer-rpcs flags require otlp-trace-file")
+	}
+	if c.FSGoferHostUDSCreate {
+		if !c.FSGoferHostUDS {
+			return fmt.Errorf("fsgofer-host-uds-create flag requires fsgofer-host-uds")
+		}
+		if !c.VFS2 {
+			return fmt.Errorf("fsgofer-host-uds-create flag requires vfs2")
+		}
+	}
+	if c.HostUDSSCMRights != "all" && !c.VFS2 {
+		return fmt.Errorf("host-uds-scm-rights flag requires vfs2")
+	}
+	if c.IdleReclaimSec != 0 && c.Platform != "kvm" {
+		return fmt.Errorf("idle-reclaim-sec flag requires the kvm platform")
+	}
+	switch c.Hugepages {
+	case "none", "thp", "2m", "1g":
+	default:
+		return fmt.Errorf("invalid hugepages %q, must be none, thp, 2m or 1g", c.Hugepages)
+	}
+	if c.AttestationDevice && !c.VFS2 {
+		return fmt.Errorf("attestation-device flag requires vfs2")
+	}
+	if c.ROCm && !c.VFS2 {
+		return fmt.Errorf("rocm flag requires vfs2")
+	}
+	if c.MaxCPUs < 0 {
+		return fmt.Errorf("max-cpus must be >= 0, got: %d", c.MaxCPUs)
+	}
 	if c.NumNetworkChannels <= 0 {
 		return fmt.Errorf("num_network_channels must be > 0, got: %d", c.NumNetworkChannels)
 	}
+	if c.DNSStub {
+		if c.Network == NetworkHost {
+			return fmt.Errorf("dns-stub flag is incompatible with host network")
+		}
+		if c.DNSUpstreams == "" && c.DNSForward == "" {
+			return fmt.Errorf("dns-stub flag requires dns-upstreams or dns-forward")
+		}
+	}
+	if c.DNSCacheSize < 0 {
+		return fmt.Errorf("dns_cache_size must be >= 0, got: %d", c.DNSCacheSize)
+	}
+	if c.GoferDirtyBackgroundBytes > c.GoferDirtyBytes {
+		return fmt.Errorf("gofer-dirty-background-bytes must be <= gofer-dirty-bytes, got: %d > %d", c.GoferDirtyBackgroundBytes, c.GoferDirtyBytes)
+	}
 	return nil
 }
 
@@ -169,6 +169,37 @@ func TestValidationFail(t *testing.T) {
 			},
 			error: "num_network_channels must be > 0",
 		},
+		{
+			name: "dns-stub+host",
+			flags: map[string]string{
+				"dns-stub":      "true",
+				"dns-upstreams": "192.0.2.1",
+				"network":       "host",
+			},
+			error: "dns-stub flag is incompatible",
+		},
+		{
+			name: "dns-stub-no-upstreams",
+			flags: map[string]string{
+				"dns-stub": "true",
+			},
+			error: "dns-stub flag requires",
+		},
+		{
+			name: "gofer-dirty-background-bytes",
+			flags: map[string]string{
+				"gofer-dirty-bytes":            "4096",
+				"gofer-dirty-background-bytes": "8192",
+			},
+			error: "gofer-dirty-background-bytes must be <= gofer-dirty-bytes",
+		},
+		{
+			name: "watchdog-checkpoint-then-panic",
+			flags: map[string]string{
+				"watchdog-action": "checkpoint-then-panic",
+			},
+			error: "requires watchdog-checkpoint",
+		},
 	} {
 		t.Run(tc.name, func(t *testing.T) {
 			for name, val := range tc.flags {
@@ -236,6 +267,27 @@ func TestOverrideDisabled(t *testing.T) {
 	}
 }
 
+func TestContainerOverride(t *testing.T) {
+	c, err := NewFromFlags()
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.AllowContainerFlagOverride = true
+
+	if err := c.Override("strace", "true"); err != nil {
+		t.Fatalf("Override(strace, true) failed: %v", err)
+	}
+	defer setDefault("strace")
+	if !c.Strace {
+		t.Errorf("Override(strace, true) didn't work: %+v", c)
+	}
+
+	const errMsg = "flag override disabled"
+	if err := c.Override("root", "path"); err == nil || !strings.Contains(err.Error(), errMsg) {
+		t.Errorf("Override(root, path) wrong error: %v", err)
+	}
+}
+
 func TestOverrideError(t *testing.T) {
 	c, err := NewFromFlags()
 	if err != nil {
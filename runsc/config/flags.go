@@ -38,6 +38,7 @@ func RegisterFlags() {
 		flag.String("log", "", "file path where internal debug information is written, default is stdout.")
 		flag.String("log-format", "text", "log format: text (default), json, or json-k8s.")
 		flag.Bool("debug", false, "enable debug logging.")
+		flag.Bool("systemd-cgroup", false, "creates the sandbox cgroup as a systemd scope. Cgroups paths must have the form slice:prefix:name. Requires cgroup v2.")
 
 		// These flags are unique to runsc, and are used to configure parts of the
 		// system that are not covered by the runtime spec.
@@ -47,32 +48,71 @@ func RegisterFlags() {
 		flag.String("panic-log", "", "file path were panic reports and other Go's runtime messages are written.")
 		flag.Bool("log-packets", false, "enable network packet logging.")
 		flag.String("debug-log-format", "text", "log format: text (default), json, or json-k8s.")
+		flag.String("otlp-trace-file", "", "file to which OpenTelemetry spans for sandbox lifecycle events and checkpoints are written as OTLP/JSON, e.g. for the OpenTelemetry Collector's otlpjsonfile receiver. Spans are part of the trace given by the dev.gvisor.traceparent annotation, or of a trace derived from the sandbox ID. Accepts the same variables as --debug-log.")
+		flag.Uint("otlp-syscall-threshold-us", 0, "exports spans for syscalls that take at least this many microseconds. 0 disables it. Requires --otlp-trace-file.")
+		flag.Bool("otlp-gofer-rpcs", false, "exports spans for gofer RPCs. Requires --otlp-trace-file.")
 		flag.Bool("alsologtostderr", false, "send log messages to stderr.")
 		flag.Bool("allow-flag-override", false, "allow OCI annotations (dev.gvisor.flag.<name>) to override flags for debugging.")
+		flag.Bool("allow-container-flag-override", false, "allow OCI annotations (dev.gvisor.flag.<name>) to override debug, overlay, strace and strace-syscalls, which apply to each container of a sandbox separately.")
 		flag.String("traceback", "system", "golang runtime's traceback level")
 
 		// Debugging flags: strace related
 		flag.Bool("strace", false, "enable strace.")
 		flag.String("strace-syscalls", "", "comma-separated list of syscalls to trace. If --strace is true and this list is empty, then all syscalls will be traced.")
 		flag.Uint("strace-log-size", 1024, "default size (in bytes) to log data argument blobs.")
+		flag.String("strace-format", "text", "format of straces: text, or json for one object per syscall with its pid, tid, arguments, result and duration.")
+		flag.String("strace-rate-limit", "", `comma-separated list of <syscall>:<rate> limits on the number of traced syscalls per second. A syscall of "*" applies to each syscall without its own limit.`)
+		flag.String("strace-redact", "", `comma-separated list of syscall arguments to redact from straces: <syscall>:<argument index, from 0>, <syscall> for all of its arguments, or "buffers" for the data buffers of all syscalls.`)
 
 		// Flags that control sandbox runtime behavior.
 		flag.String("platform", "ptrace", "specifies which platform to use: ptrace (default), kvm.")
-		flag.Var(watchdogActionPtr(watchdog.LogWarning), "watchdog-action", "sets what action the watchdog takes when triggered: log (default), panic.")
+		flag.Uint("idle-reclaim-sec", 0, "returns freed and cached sandbox memory to the host once applications have been idle for this many seconds. 0 disables it. Requires the kvm platform.")
+		flag.Bool("rocm", false, "exposes the host's AMD ROCm compute device, /dev/kfd, to the sandbox. Only ioctls that query the driver are supported so far. Requires VFSv2.")
+		flag.String("cdi-spec-dirs", "/etc/cdi,/var/run/cdi", "comma-separated list of directories with Container Device Interface (CDI) specs in JSON format, used to inject the devices requested by cdi.k8s.io/ annotations into containers. Specs in later directories take precedence. Empty disables CDI.")
+		flag.Int("max-cpus", 0, "number of CPUs that 'runsc update' can bring online in the sandbox. 0 means the sandbox can't grow beyond its initial number of CPUs.")
+		flag.String("cpu-features", "", "comma-separated list of CPU features, as named in /proc/cpuinfo, that applications may use. Other host features are hidden, so that checkpoints can be restored on hosts with a different CPU model or vendor that have the listed features. Empty means all host features.")
+		flag.String("hugepages", "none", "backs sandbox memory with host hugepages: none, thp (transparent hugepages; requires the host's shmem_enabled to be advise or within_size), 2m or 1g (hugetlbfs pages, which must be reserved on the host).")
+		flag.Bool("attestation-device", false, "exposes the host's confidential computing attestation device, /dev/sev-guest (AMD SEV-SNP) or /dev/tdx_guest (Intel TDX), to the sandbox. runsc must run in a confidential guest. Requires VFSv2.")
+		flag.Var(watchdogActionPtr(watchdog.LogWarning), "watchdog-action", "sets what action the watchdog takes when triggered: log (default), panic, checkpoint-then-panic.")
+		flag.Uint("watchdog-task-timeout-sec", 180, "number of seconds a task may run in the sentry without blocking before the watchdog considers it stuck. 0 disables it.")
+		flag.Uint("watchdog-startup-timeout-sec", 30, "number of seconds the sandbox may take to start before the watchdog is triggered.")
+		flag.String("watchdog-checkpoint", "", "host file to which the sandbox is checkpointed by the checkpoint-then-panic watchdog action, before panicking. If it is a directory, a file named after the sandbox is used.")
 		flag.Int("panic-signal", -1, "register signal handling that panics. Usually set to SIGUSR2(12) to troubleshoot hangs. -1 disables it.")
 		flag.Bool("profile", false, "prepares the sandbox to use Golang profiler. Note that enabling profiler loosens the seccomp protection added to the sandbox (DO NOT USE IN PRODUCTION).")
+		flag.String("profile-ring", "", "host file in which the sandbox continuously keeps its recent CPU and allocation profiles, collected at low frequency, for 'runsc debug --profile-dump' to snapshot after an incident. If it is a directory, a file named after the sandbox is used. Requires --profile.")
+		flag.Uint("profile-ring-size", 64<<20, "size of the --profile-ring file in bytes.")
+		flag.Bool("fs-stats", false, "collects per-mount counts, sizes and latencies of file operations, reported by 'runsc debug --fsstats' and as metrics. Requires VFSv2.")
+		flag.Uint("fs-slow-op-ms", 0, "logs file operations that take at least this many milliseconds, naming the file and operation. 0 disables it. Requires VFSv2.")
+		flag.Bool("syscall-stats", false, "collects per-syscall latency histograms and the slowest recent syscalls, reported by 'runsc debug --syscall-stats' and as metrics. Adds two clock reads to every syscall.")
+		flag.String("core-pattern", "", `enables core dumps of crashing processes, limited by their RLIMIT_CORE. The pattern is the name of the core files in the sandbox, relative to the working directory of the process, with the specifiers of Linux's core_pattern %p, %P, %i, %I, %u, %g, %s, %t, %h, %e and %%; it requires VFSv2. If it is "|<command> <args>", the host command is started with the sandbox and reads all core dumps on its stdin, each preceded by a "core pid=... size=<bytes>" header line. Empty disables core dumps.`)
 		flag.Bool("rootless", false, "it allows the sandbox to be started with a user that is not root. Sandbox and Gofer processes may run with same privileges as current user.")
+		flag.Bool("podman", false, "enables compatibility with podman: saves the sandbox exit status for conmon, checks --console-socket like runc and skips cgroups that rootless users can't configure.")
 		flag.Var(leakModePtr(refs.NoLeakChecking), "ref-leak-mode", "sets reference leak check mode: disabled (default), log-names, log-traces.")
 		flag.Bool("cpu-num-from-quota", false, "set cpu number to cpu quota (least integer greater or equal to quota value, but not less than 2)")
+		flag.String("cgroup-cpuset-partition", "", "makes the sandbox cgroup a cpuset partition of this type: member, root or isolated. Requires cgroup v2.")
 		flag.Bool("oci-seccomp", false, "Enables loading OCI seccomp filters inside the sandbox.")
 
 		// Flags that control sandbox runtime behavior: FS related.
 		flag.Var(fileAccessTypePtr(FileAccessExclusive), "file-access", "specifies which filesystem to use for the root mount: exclusive (default), shared. Volume mounts are always shared.")
 		flag.Bool("overlay", false, "wrap filesystem mounts with writable overlay. All modifications are stored in memory inside the sandbox.")
+		flag.String("overlay-upper", "", "host file or directory in which to persist the upper layer of the root overlay across restarts, instead of keeping it in memory. Requires --overlay and VFSv2.")
+		flag.String("tmpfs-spill-dir", "", "host directory in which to store tmpfs data that exceeds the spill_threshold mount option, instead of keeping it in memory. Requires VFSv2.")
+		flag.String("gofer-read-cache", "", "host file in which to cache the contents of files read from gofer mounts that the sandbox doesn't write to (read-only mounts and the lower layers of overlays). The file may be shared by sandboxes on the same host. Requires VFSv2.")
+		flag.Uint("gofer-read-cache-size", 1<<30, "size of the --gofer-read-cache file in bytes.")
 		flag.Bool("overlayfs-stale-read", true, "assume root mount is an overlay filesystem")
 		flag.Bool("fsgofer-host-uds", false, "allow the gofer to mount Unix Domain Sockets.")
+		flag.Bool("fsgofer-host-uds-create", false, "make binding a stream or seqpacket Unix Domain Socket to a path on a gofer mount create a socket file that host processes can connect to. Requires --fsgofer-host-uds and VFSv2.")
+		flag.String("host-uds-scm-rights", "all", "comma-separated list of file types that may be passed through host Unix Domain Sockets with SCM_RIGHTS: file, dir, pipe, socket, chardev, blockdev, other; or all or none. Requires VFSv2 unless all.")
+		flag.Bool("fsgofer-invalidations", false, "report changes made to files by the host with inotify, letting the sandbox cache metadata of shared files. Requires VFSv2.")
+		flag.Bool("gofer-remote-locks", false, "take POSIX and flock(2) locks on host files through the gofer for all mounts, so that they are coherent with host processes and other sandboxes. Mounts with shared file access always do so. Requires VFSv2.")
+		flag.Uint("gofer-dirty-bytes", 0, "enables write-back caching of files for which the gofer doesn't donate host FDs, with at most this many bytes of dirty data per mount. 0 disables it. Requires VFSv2 and exclusive file access.")
+		flag.Uint("gofer-dirty-background-bytes", 0, "amount of dirty data per mount above which it is written back in the background. 0 selects half of --gofer-dirty-bytes.")
+		flag.Uint("gofer-readahead", 0, "number of bytes read into the page cache at a time from files of gofer mounts, unless more are required. 0 selects the default of 64KiB. Requires VFSv2.")
+		flag.String("lazy-image-cache", "", "directory where the gofer caches the files that it fetches for root filesystems that are lazily loaded eStargz images. If empty, they are only cached in memory.")
+		flag.Int("gofer-channels", 0, "number of channels used to send concurrent requests to the gofer for each mount, up to 16. 0 selects a default based on the number of CPUs.")
 		flag.Bool("vfs2", false, "enables VFSv2. This uses the new VFS layer that is faster than the previous one.")
 		flag.Bool("fuse", false, "TEST ONLY; use while FUSE in VFSv2 is landing. This allows the use of the new experimental FUSE filesystem.")
+		flag.Bool("cgroupfs", false, "emulate a cgroup v2 filesystem for cgroup mounts, so that container runtimes such as dockerd can run in the sandbox. Limits written to it are recorded but not enforced. Requires VFSv2.")
 
 		// Flags that control sandbox runtime behavior: network related.
 		flag.Var(networkTypePtr(NetworkSandbox), "network", "specifies which network to use: sandbox (default), host, none. Using network inside the sandbox is more secure because it's isolated from the host network.")
@@ -82,7 +122,13 @@ func RegisterFlags() {
 		flag.Bool("tx-checksum-offload", false, "enable TX checksum offload.")
 		flag.Bool("rx-checksum-offload", true, "enable RX checksum offload.")
 		flag.Var(queueingDisciplinePtr(QDiscFIFO), "qdisc", "specifies which queueing discipline to apply by default to the non loopback nics used by the sandbox.")
+		flag.Bool("tcp-checkpoint", false, "preserve established TCP connections across checkpoint and restore, e.g. to upgrade runsc in place. Otherwise, checkpoint fails if there are any.")
+		flag.Uint("tcp-restore-window-sec", 0, "reset TCP connections that were suspended by checkpoint for longer than this many seconds, instead of resuming them on restore. 0 resumes them regardless.")
 		flag.Int("num-network-channels", 1, "number of underlying channels(FDs) to use for network link endpoints.")
+		flag.Bool("dns-stub", false, "enable a caching DNS stub resolver listening on 127.0.0.53 in the sandbox network stack. Containers use it when it is the nameserver of their /etc/resolv.conf.")
+		flag.String("dns-upstreams", "", "comma-separated list of DNS servers the DNS stub resolver forwards queries to: addr[:port] or tls://addr[:port][#name] for DNS-over-TLS.")
+		flag.String("dns-forward", "", "comma-separated list of domain=server conditional forwarding rules of the DNS stub resolver, with servers as in --dns-upstreams.")
+		flag.Int("dns-cache-size", 1000, "maximum number of responses cached by the DNS stub resolver. 0 disables caching.")
 
 		// Test flags, not to be used outside tests, ever.
 		flag.Bool("TESTONLY-unsafe-nonroot", false, "TEST ONLY; do not ever use! This skips many security measures that isolate the host from the sandbox.")
@@ -152,9 +198,19 @@ func (c *Config) ToFlags() []string {
 	return rv
 }
 
+// ContainerFlags are the flags that can be set for each container of a
+// sandbox, rather than for the whole sandbox. They can be overridden with
+// --allow-container-flag-override.
+var ContainerFlags = map[string]struct{}{
+	"debug":           {},
+	"overlay":         {},
+	"strace":          {},
+	"strace-syscalls": {},
+}
+
 // Override writes a new value to a flag.
 func (c *Config) Override(name string, value string) error {
-	if !c.AllowFlagOverride {
+	if _, ok := ContainerFlags[name]; !c.AllowFlagOverride && !(ok && c.AllowContainerFlagOverride) {
 		return fmt.Errorf("flag override disabled, use --allow-flag-override to enable it")
 	}
 
@@ -24,6 +24,19 @@ import (
 	"golang.org/x/sys/unix"
 )
 
+// CheckSocket checks that a console socket is given if, and only if, the
+// process allocates a terminal and is detached from the caller, like runc
+// does. attached is true if the terminal is inherited from the caller.
+func CheckSocket(terminal, attached bool, socketPath string) error {
+	if socketPath != "" && !terminal {
+		return fmt.Errorf("cannot use console socket if the process doesn't allocate a tty")
+	}
+	if socketPath == "" && terminal && !attached {
+		return fmt.Errorf("cannot allocate tty if the process is detached without setting console socket")
+	}
+	return nil
+}
+
 // NewWithSocket creates pty master/replica pair, sends the master FD over the
 // given socket, and returns the replica.
 func NewWithSocket(socketPath string) (*os.File, error) {
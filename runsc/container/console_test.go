@@ -162,6 +162,38 @@ func TestConsoleSocket(t *testing.T) {
 	}
 }
 
+// Test that podman mode rejects a console socket for a process that doesn't
+// allocate a terminal, like runc does.
+func TestPodmanConsoleSocketWithoutTerminal(t *testing.T) {
+	conf := testutil.TestConfig(t)
+	conf.Podman = true
+
+	spec := testutil.NewSpecWithArgs("true")
+	_, bundleDir, cleanup, err := testutil.SetupContainer(spec, conf)
+	if err != nil {
+		t.Fatalf("error setting up container: %v", err)
+	}
+	defer cleanup()
+
+	sock, err := socketPath(bundleDir)
+	if err != nil {
+		t.Fatalf("error getting socket path: %v", err)
+	}
+	_, cleanup = createConsoleSocket(t, sock)
+	defer cleanup()
+
+	args := Args{
+		ID:            testutil.RandomContainerID(),
+		Spec:          spec,
+		BundleDir:     bundleDir,
+		ConsoleSocket: sock,
+	}
+	if c, err := New(conf, args); err == nil {
+		c.Destroy()
+		t.Fatalf("New() succeeded with a console socket and no terminal")
+	}
+}
+
 // Test that an pty FD is sent over the console socket if one is provided.
 func TestMultiContainerConsoleSocket(t *testing.T) {
 	for name, conf := range configsWithVFS2(t, all...) {
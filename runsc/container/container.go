@@ -20,6 +20,8 @@ import (
 	"errors"
 	"fmt"
 	"io/ioutil"
+	"math"
+	"net"
 	"os"
 	"os/exec"
 	"regexp"
@@ -33,12 +35,15 @@ import (
 	"gvisor.dev/gvisor/pkg/abi/linux"
 	"gvisor.dev/gvisor/pkg/cleanup"
 	"gvisor.dev/gvisor/pkg/log"
+	"gvisor.dev/gvisor/pkg/otlp"
 	"gvisor.dev/gvisor/pkg/sentry/control"
 	"gvisor.dev/gvisor/pkg/sentry/sighandling"
+	"gvisor.dev/gvisor/pkg/sentry/watchdog"
 	"gvisor.dev/gvisor/runsc/boot"
 	"gvisor.dev/gvisor/runsc/cgroup"
 	"gvisor.dev/gvisor/runsc/config"
 	"gvisor.dev/gvisor/runsc/console"
+	"gvisor.dev/gvisor/runsc/lazyimage"
 	"gvisor.dev/gvisor/runsc/sandbox"
 	"gvisor.dev/gvisor/runsc/specutils"
 )
@@ -157,16 +162,26 @@ type Args struct {
 	//
 	// It only applies for the init container.
 	Attached bool
+
+	// ListenFiles are the files passed to the container by socket activation.
+	//
+	// It only applies for the init container.
+	ListenFiles []*os.File
 }
 
 // New creates the container in a new Sandbox process, unless the metadata
 // indicates that an existing Sandbox should be used. The caller must call
 // Destroy() on the container.
-func New(conf *config.Config, args Args) (*Container, error) {
+func New(conf *config.Config, args Args) (_ *Container, err error) {
 	log.Debugf("Create container, cid: %s, rootDir: %q", args.ID, conf.RootDir)
 	if err := validateID(args.ID); err != nil {
 		return nil, err
 	}
+	if conf.Podman && args.Spec.Process != nil {
+		if err := console.CheckSocket(args.Spec.Process.Terminal, args.Attached, args.ConsoleSocket); err != nil {
+			return nil, err
+		}
+	}
 
 	if err := os.MkdirAll(conf.RootDir, 0711); err != nil {
 		return nil, fmt.Errorf("creating container root directory %q: %v", conf.RootDir, err)
@@ -179,6 +194,9 @@ func New(conf *config.Config, args Args) (*Container, error) {
 		if !ok {
 			return nil, fmt.Errorf("no sandbox ID found when creating container")
 		}
+		if len(args.ListenFiles) > 0 {
+			return nil, fmt.Errorf("socket activation is only supported for the root container")
+		}
 	}
 
 	c := &Container{
@@ -197,6 +215,9 @@ func New(conf *config.Config, args Args) (*Container, error) {
 			},
 		},
 	}
+	span := c.startSpan("container.create")
+	defer func() { span.End(err) }()
+
 	// The Cleanup object cleans up partially created containers when an error
 	// occurs. Any errors occurring during cleanup itself are ignored.
 	cu := cleanup.Make(func() { _ = c.Destroy() })
@@ -227,13 +248,22 @@ func New(conf *config.Config, args Args) (*Container, error) {
 			args.Spec.Linux = &specs.Linux{}
 		}
 		// Don't force the use of cgroups in tests because they lack permission to do so.
-		if args.Spec.Linux.CgroupsPath == "" && !conf.TestOnlyAllowRunAsCurrentUserWithoutChroot {
-			args.Spec.Linux.CgroupsPath = "/" + args.ID
+		// Podman leaves the cgroups path empty when cgroups are disabled for the
+		// container, e.g. with --cgroups=disabled.
+		if args.Spec.Linux.CgroupsPath == "" && !conf.TestOnlyAllowRunAsCurrentUserWithoutChroot && !conf.Podman {
+			if conf.SystemdCgroup {
+				args.Spec.Linux.CgroupsPath = "system.slice:runsc:" + args.ID
+			} else {
+				args.Spec.Linux.CgroupsPath = "/" + args.ID
+			}
 		}
 
 		// Create and join cgroup before processes are created to ensure they are
 		// part of the cgroup from the start (and all their children processes).
-		cg, err := cgroup.New(args.Spec)
+		cg, err := cgroup.New(args.Spec, cgroup.Options{
+			Systemd:         conf.SystemdCgroup,
+			CPUSetPartition: conf.CgroupCPUSetPartition,
+		})
 		if err != nil {
 			return nil, err
 		}
@@ -244,6 +274,11 @@ func New(conf *config.Config, args Args) (*Container, error) {
 				case errors.Is(err, syscall.EACCES) && conf.Rootless:
 					log.Warningf("Skipping cgroup configuration in rootless mode: %v", err)
 					cg = nil
+				case conf.Podman && os.Geteuid() != 0 && isCgroupPermissionError(err):
+					// Rootless podman only gets cgroups delegated to the user, e.g. by
+					// systemd, and runs containers without them otherwise.
+					log.Warningf("Skipping cgroup configuration for rootless podman: %v", err)
+					cg = nil
 				default:
 					return nil, fmt.Errorf("configuring cgroup: %v", err)
 				}
@@ -267,6 +302,12 @@ func New(conf *config.Config, args Args) (*Container, error) {
 				MountsFile:    specFile,
 				Cgroup:        cg,
 				Attached:      args.Attached,
+				ListenFiles:   args.ListenFiles,
+			}
+			if conf.Podman {
+				// conmon reaps the sandbox process, so its exit status must be
+				// saved for the runsc commands that wait for it.
+				sandArgs.ExitFile = c.Saver.exitPath()
 			}
 			sand, err := sandbox.New(conf, sandArgs)
 			if err != nil {
@@ -310,6 +351,16 @@ func New(conf *config.Config, args Args) (*Container, error) {
 			return nil, err
 		}
 	}
+
+	// "If any createContainer hook fails, the runtime MUST generate an error,
+	// stop the container" -OCI spec. The sandbox can't run host binaries in
+	// the container, so these hooks run on the host, resolved in the runtime
+	// namespace, while the container is being created.
+	if c.Spec.Hooks != nil {
+		if err := executeHooks(c.Spec.Hooks.CreateContainer, c.State()); err != nil {
+			return nil, err
+		}
+	}
 	c.changeStatus(Created)
 
 	// Save the metadata file.
@@ -330,8 +381,10 @@ func New(conf *config.Config, args Args) (*Container, error) {
 }
 
 // Start starts running the containerized process inside the sandbox.
-func (c *Container) Start(conf *config.Config) error {
+func (c *Container) Start(conf *config.Config) (err error) {
 	log.Debugf("Start container, cid: %s", c.ID)
+	span := c.startSpan("container.start")
+	defer func() { span.End(err) }()
 
 	if err := c.Saver.lock(); err != nil {
 		return err
@@ -417,8 +470,10 @@ func (c *Container) Start(conf *config.Config) error {
 
 // Restore takes a container and replaces its kernel and file system
 // to restore a container from its state file.
-func (c *Container) Restore(spec *specs.Spec, conf *config.Config, restoreFile string) error {
+func (c *Container) Restore(spec *specs.Spec, conf *config.Config, restoreFile string) (err error) {
 	log.Debugf("Restore container, cid: %s", c.ID)
+	span := c.startSpan("container.restore")
+	defer func() { span.End(err) }()
 	if err := c.Saver.lock(); err != nil {
 		return err
 	}
@@ -476,8 +531,10 @@ func Run(conf *config.Config, args Args) (syscall.WaitStatus, error) {
 
 // Execute runs the specified command in the container. It returns the PID of
 // the newly created process.
-func (c *Container) Execute(args *control.ExecArgs) (int32, error) {
+func (c *Container) Execute(args *control.ExecArgs) (_ int32, err error) {
 	log.Debugf("Execute in container, cid: %s, args: %+v", c.ID, args)
+	span := c.startSpan("container.exec")
+	defer func() { span.End(err) }()
 	if err := c.requireStatus("execute in", Created, Running); err != nil {
 		return 0, err
 	}
@@ -494,6 +551,16 @@ func (c *Container) Event() (*boot.Event, error) {
 	return c.Sandbox.Event(c.ID)
 }
 
+// StallEvents returns the stalls detected by the sandbox's watchdog after the
+// one numbered after.
+func (c *Container) StallEvents(after uint64) ([]watchdog.StallEvent, error) {
+	log.Debugf("Getting stall events for container, cid: %s", c.ID)
+	if err := c.requireStatus("get events for", Created, Running, Paused); err != nil {
+		return nil, err
+	}
+	return c.Sandbox.StallEvents(after)
+}
+
 // SandboxPid returns the Pid of the sandbox the container is running in, or -1 if the
 // container is not running.
 func (c *Container) SandboxPid() int {
@@ -587,18 +654,36 @@ func (c *Container) ForwardSignals(pid int32, fgProcess bool) func() {
 
 // Checkpoint sends the checkpoint call to the container.
 // The statefile will be written to f, the file at the specified image-path.
-func (c *Container) Checkpoint(f *os.File) error {
+func (c *Container) Checkpoint(f *os.File, opts sandbox.CheckpointOpts) (err error) {
 	log.Debugf("Checkpoint container, cid: %s", c.ID)
+	span := c.startSpan("container.checkpoint")
+	defer func() { span.End(err) }()
 	if err := c.requireStatus("checkpoint", Created, Running, Paused); err != nil {
 		return err
 	}
-	return c.Sandbox.Checkpoint(c.ID, f)
+	return c.Sandbox.Checkpoint(c.ID, f, opts)
+}
+
+// Migrate writes the state of the container's sandbox to f while the sandbox
+// runs, pausing it for about maxPause at the end. The sandbox exits once
+// migration succeeds. If maxRounds is non-zero, it bounds the number of times
+// memory is copied while the sandbox runs.
+func (c *Container) Migrate(f *os.File, maxPause time.Duration, maxRounds int) (err error) {
+	log.Debugf("Migrate container, cid: %s", c.ID)
+	span := c.startSpan("container.migrate")
+	defer func() { span.End(err) }()
+	if err := c.requireStatus("migrate", Running, Paused); err != nil {
+		return err
+	}
+	return c.Sandbox.Migrate(c.ID, f, maxPause, maxRounds)
 }
 
 // Pause suspends the container and its kernel.
 // The call only succeeds if the container's status is created or running.
-func (c *Container) Pause() error {
+func (c *Container) Pause() (err error) {
 	log.Debugf("Pausing container, cid: %s", c.ID)
+	span := c.startSpan("container.pause")
+	defer func() { span.End(err) }()
 	if err := c.Saver.lock(); err != nil {
 		return err
 	}
@@ -617,8 +702,10 @@ func (c *Container) Pause() error {
 
 // Resume unpauses the container and its kernel.
 // The call only succeeds if the container's status is paused.
-func (c *Container) Resume() error {
+func (c *Container) Resume() (err error) {
 	log.Debugf("Resuming container, cid: %s", c.ID)
+	span := c.startSpan("container.resume")
+	defer func() { span.End(err) }()
 	if err := c.Saver.lock(); err != nil {
 		return err
 	}
@@ -654,10 +741,173 @@ func (c *Container) Processes() ([]*control.Process, error) {
 	return c.Sandbox.Processes(c.ID)
 }
 
+// Prefetch reads the files at the given paths in the container, recursing
+// into directories, so that their contents are cached by the time the
+// container's applications read them. It returns the number of files read.
+func (c *Container) Prefetch(paths []string) (int, error) {
+	if err := c.requireStatus("prefetch files in", Running, Paused); err != nil {
+		return 0, err
+	}
+	return c.Sandbox.Prefetch(c.ID, paths)
+}
+
+// Resize changes the number of CPUs and the amount of memory visible to
+// applications in the container's sandbox. Zero values leave the
+// corresponding resource unchanged.
+func (c *Container) Resize(cpus uint, totalMem uint64) error {
+	if err := c.requireStatus("resize", Created, Running, Paused); err != nil {
+		return err
+	}
+	return c.Sandbox.Resize(cpus, totalMem)
+}
+
+// Update changes the resources of the container to res, as "runc update"
+// does. If the container is the root container, the sandbox's host cgroup is
+// updated; the host cgroups of other containers are managed by the caller,
+// e.g. the Kubelet's pod cgroup. The CPUs and memory visible to applications
+// in the sandbox are then resized to those of all of its containers.
+func (c *Container) Update(conf *config.Config, res *specs.LinuxResources) (err error) {
+	log.Debugf("Updating container resources, cid: %s", c.ID)
+	span := c.startSpan("container.update")
+	defer func() { span.End(err) }()
+	if err := c.requireStatus("update", Created, Running, Paused); err != nil {
+		return err
+	}
+	if err := c.updateResources(res); err != nil {
+		return err
+	}
+	if c.Sandbox.IsRootContainer(c.ID) && c.Sandbox.Cgroup != nil {
+		if err := c.Sandbox.Cgroup.Update(res); err != nil {
+			return fmt.Errorf("updating cgroup: %v", err)
+		}
+	}
+
+	// The state of all containers is loaded, so this can't be done with c
+	// locked.
+	containers, err := loadSandbox(c.Saver.RootDir, c.Sandbox.ID)
+	if err != nil {
+		return err
+	}
+	return c.Sandbox.ResizeToResources(conf, sandboxResources(containers))
+}
+
+// updateResources sets the resources in the container's spec that are set in
+// res, and saves the spec.
+func (c *Container) updateResources(res *specs.LinuxResources) error {
+	if err := c.Saver.lock(); err != nil {
+		return err
+	}
+	defer c.Saver.unlock()
+
+	if c.Spec.Linux == nil {
+		c.Spec.Linux = &specs.Linux{}
+	}
+	if c.Spec.Linux.Resources == nil {
+		c.Spec.Linux.Resources = &specs.LinuxResources{}
+	}
+	cur := c.Spec.Linux.Resources
+	if res.CPU != nil {
+		if cur.CPU == nil {
+			cur.CPU = &specs.LinuxCPU{}
+		}
+		if res.CPU.Shares != nil {
+			cur.CPU.Shares = res.CPU.Shares
+		}
+		if res.CPU.Quota != nil {
+			cur.CPU.Quota = res.CPU.Quota
+		}
+		if res.CPU.Period != nil {
+			cur.CPU.Period = res.CPU.Period
+		}
+		if res.CPU.Cpus != "" {
+			cur.CPU.Cpus = res.CPU.Cpus
+		}
+		if res.CPU.Mems != "" {
+			cur.CPU.Mems = res.CPU.Mems
+		}
+	}
+	if res.Memory != nil {
+		if cur.Memory == nil {
+			cur.Memory = &specs.LinuxMemory{}
+		}
+		if res.Memory.Limit != nil {
+			cur.Memory.Limit = res.Memory.Limit
+		}
+		if res.Memory.Reservation != nil {
+			cur.Memory.Reservation = res.Memory.Reservation
+		}
+		if res.Memory.Swap != nil {
+			cur.Memory.Swap = res.Memory.Swap
+		}
+	}
+	if res.Pids != nil {
+		cur.Pids = res.Pids
+	}
+	return c.saveLocked()
+}
+
+// sandboxResources returns the CPU quota and memory limit of a sandbox, which
+// are the sums of those of its application containers. The container that
+// holds the sandbox for a CRI pod doesn't count. If an application container
+// has no limit, neither does the sandbox.
+func sandboxResources(containers []*Container) *specs.LinuxResources {
+	// The default CFS period, in microseconds.
+	const period = 100000
+
+	var (
+		cpus       float64
+		mem        int64
+		cpuLimited = true
+		memLimited = true
+		apps       int
+	)
+	for _, c := range containers {
+		if c.Status == Stopped || specutils.SpecContainerType(c.Spec) == specutils.ContainerTypeSandbox {
+			continue
+		}
+		apps++
+		var res *specs.LinuxResources
+		if c.Spec.Linux != nil {
+			res = c.Spec.Linux.Resources
+		}
+		if res != nil && res.CPU != nil && res.CPU.Quota != nil && *res.CPU.Quota > 0 && res.CPU.Period != nil && *res.CPU.Period > 0 {
+			cpus += float64(*res.CPU.Quota) / float64(*res.CPU.Period)
+		} else {
+			cpuLimited = false
+		}
+		if res != nil && res.Memory != nil && res.Memory.Limit != nil && *res.Memory.Limit > 0 {
+			mem += *res.Memory.Limit
+		} else {
+			memLimited = false
+		}
+	}
+
+	total := &specs.LinuxResources{}
+	if apps == 0 {
+		return total
+	}
+	if cpuLimited {
+		quota := int64(math.Ceil(cpus * period))
+		p := uint64(period)
+		total.CPU = &specs.LinuxCPU{
+			Quota:  &quota,
+			Period: &p,
+		}
+	}
+	if memLimited {
+		total.Memory = &specs.LinuxMemory{
+			Limit: &mem,
+		}
+	}
+	return total
+}
+
 // Destroy stops all processes and frees all resources associated with the
 // container.
-func (c *Container) Destroy() error {
+func (c *Container) Destroy() (err error) {
 	log.Debugf("Destroy container, cid: %s", c.ID)
+	span := c.startSpan("container.destroy")
+	defer func() { span.End(err) }()
 
 	if err := c.Saver.lock(); err != nil {
 		return err
@@ -724,6 +974,19 @@ func (c *Container) Destroy() error {
 	return fmt.Errorf(strings.Join(errs, "\n"))
 }
 
+// startSpan starts a span for an operation on the container, which is exported
+// with otlp.Default when it ends.
+func (c *Container) startSpan(name string) *otlp.ActiveSpan {
+	tracer := otlp.Default()
+	if tracer == nil {
+		return nil
+	}
+	sandboxID := c.Saver.ID.SandboxID
+	return tracer.WithParent(specutils.TraceParent(c.Spec, sandboxID)).Start(name,
+		otlp.String("container.id", c.ID),
+		otlp.String("gvisor.sandbox.id", sandboxID))
+}
+
 // saveLocked saves the container metadata to a file.
 //
 // Precondition: container must be locked with container.lock().
@@ -874,18 +1137,70 @@ func (c *Container) createGoferProcess(spec *specs.Spec, conf *config.Config, bu
 	// Add root mount and then add any other additional mounts.
 	mountCount := 1
 	for _, m := range spec.Mounts {
-		if specutils.Is9PMount(m) {
+		if specutils.Is9PMount(m) || specutils.IsVirtioFSMount(m) || specutils.IsNFSMount(m) || specutils.IsImageMount(m) {
 			mountCount++
 		}
 	}
 
+	rootfsType, rootfsImage, _ := specutils.RootfsImage(spec)
+	if rootfsImage != "" && !conf.VFS2 {
+		return nil, nil, fmt.Errorf("%s root filesystem requires VFS2", rootfsType)
+	}
+	lazyImage, isLazyImage := specutils.RootfsLazyImage(spec)
+	if isLazyImage && !conf.Overlay && !spec.Root.Readonly {
+		return nil, nil, fmt.Errorf("root filesystem from image %q is read-only, it requires --overlay or a read-only root", lazyImage)
+	}
+
+	// The sandbox consumes the FDs of mounts in the order in which the mounts
+	// appear in the spec. virtiofs and NFS mounts don't go through the gofer:
+	// the sandbox is given a connection to their vhost-user socket or server
+	// instead. Similarly, the sandbox is given the image of EROFS and SquashFS
+	// mounts.
 	sandEnds := make([]*os.File, 0, mountCount)
-	for i := 0; i < mountCount; i++ {
+	for i, m := range append([]specs.Mount{{}}, spec.Mounts...) {
+		if i != 0 && specutils.IsImageMount(m) {
+			if !conf.VFS2 {
+				return nil, nil, fmt.Errorf("%s mount %q requires VFS2", m.Type, m.Destination)
+			}
+			image, err := os.Open(m.Source)
+			if err != nil {
+				return nil, nil, fmt.Errorf("opening image of %s mount %q: %v", m.Type, m.Destination, err)
+			}
+			sandEnds = append(sandEnds, image)
+			continue
+		}
+		if i != 0 && specutils.IsVirtioFSMount(m) {
+			if !conf.VFS2 {
+				return nil, nil, fmt.Errorf("virtiofs mount %q requires VFS2", m.Destination)
+			}
+			sandEnd, err := connectVirtioFS(m.Source)
+			if err != nil {
+				return nil, nil, fmt.Errorf("connecting to vhost-user socket %q of mount %q: %v", m.Source, m.Destination, err)
+			}
+			sandEnds = append(sandEnds, sandEnd)
+			continue
+		}
+		if i != 0 && specutils.IsNFSMount(m) {
+			if !conf.VFS2 {
+				return nil, nil, fmt.Errorf("NFS mount %q requires VFS2", m.Destination)
+			}
+			server, _ := specutils.SplitNFSSource(m.Source)
+			sandEnd, err := connectNFS(server)
+			if err != nil {
+				return nil, nil, fmt.Errorf("connecting to NFS server %q of mount %q: %v", server, m.Destination, err)
+			}
+			sandEnds = append(sandEnds, sandEnd)
+			continue
+		}
+		if i != 0 && !specutils.Is9PMount(m) {
+			continue
+		}
+
 		fds, err := syscall.Socketpair(syscall.AF_UNIX, syscall.SOCK_STREAM|syscall.SOCK_CLOEXEC, 0)
 		if err != nil {
 			return nil, nil, err
 		}
-		sandEnds = append(sandEnds, os.NewFile(uintptr(fds[0]), "sandbox IO FD"))
+		sandEnd := os.NewFile(uintptr(fds[0]), "sandbox IO FD")
 
 		goferEnd := os.NewFile(uintptr(fds[1]), "gofer IO FD")
 		defer goferEnd.Close()
@@ -893,6 +1208,43 @@ func (c *Container) createGoferProcess(spec *specs.Spec, conf *config.Config, bu
 
 		args = append(args, fmt.Sprintf("--io-fds=%d", nextFD))
 		nextFD++
+
+		if i == 0 && rootfsImage != "" {
+			// The gofer still serves the root directory, but the sandbox reads
+			// the root filesystem from the image instead. Closing the
+			// sandbox's end of the connection stops the gofer's server.
+			sandEnd.Close()
+			sandEnd, err = os.Open(rootfsImage)
+			if err != nil {
+				return nil, nil, fmt.Errorf("opening root filesystem image: %v", err)
+			}
+		}
+		sandEnds = append(sandEnds, sandEnd)
+	}
+
+	if isLazyImage {
+		// The gofer has no network access, so the files of the image are
+		// fetched from its registry by a separate process.
+		fetcherEnds := make([]*os.File, 0, lazyimage.FetcherConns)
+		for i := 0; i < lazyimage.FetcherConns; i++ {
+			fds, err := syscall.Socketpair(syscall.AF_UNIX, syscall.SOCK_STREAM|syscall.SOCK_CLOEXEC, 0)
+			if err != nil {
+				return nil, nil, err
+			}
+			fetcherEnd := os.NewFile(uintptr(fds[0]), "fetcher FD")
+			defer fetcherEnd.Close()
+			fetcherEnds = append(fetcherEnds, fetcherEnd)
+
+			goferEnd := os.NewFile(uintptr(fds[1]), "gofer fetcher FD")
+			defer goferEnd.Close()
+			goferEnds = append(goferEnds, goferEnd)
+
+			args = append(args, fmt.Sprintf("--fetcher-fds=%d", nextFD))
+			nextFD++
+		}
+		if err := startFetcherProcess(spec, conf, fetcherEnds, attached); err != nil {
+			return nil, nil, fmt.Errorf("fetcher: %v", err)
+		}
 	}
 
 	binPath := specutils.ExePath
@@ -940,6 +1292,103 @@ func (c *Container) createGoferProcess(spec *specs.Spec, conf *config.Config, bu
 	return sandEnds, mountsSand, nil
 }
 
+// startFetcherProcess starts the process that fetches the files of the lazily
+// loaded root image from its registry for the gofer, over the connections in
+// conns. Unlike the gofer, the fetcher stays in the host's network namespace,
+// but it has no privileges: it runs as nobody if runsc runs as root, and it
+// can't access the gofer's files. It exits once the gofer closes its ends of
+// conns.
+func startFetcherProcess(spec *specs.Spec, conf *config.Config, conns []*os.File, attached bool) error {
+	args := conf.ToFlags()
+	var files []*os.File
+	nextFD := 3
+
+	if conf.LogFilename != "" {
+		logFile, err := os.OpenFile(conf.LogFilename, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+		if err != nil {
+			return fmt.Errorf("opening log file %q: %v", conf.LogFilename, err)
+		}
+		defer logFile.Close()
+		files = append(files, logFile)
+		args = append(args, "--log-fd="+strconv.Itoa(nextFD))
+		nextFD++
+	}
+
+	if conf.DebugLog != "" {
+		test := ""
+		if len(conf.TestOnlyTestNameEnv) != 0 {
+			if t, ok := specutils.EnvVar(spec.Process.Env, conf.TestOnlyTestNameEnv); ok {
+				test = t
+			}
+		}
+		debugLogFile, err := specutils.DebugLogFile(conf.DebugLog, "fetcher", test)
+		if err != nil {
+			return fmt.Errorf("opening debug log file in %q: %v", conf.DebugLog, err)
+		}
+		defer debugLogFile.Close()
+		files = append(files, debugLogFile)
+		args = append(args, "--debug-log-fd="+strconv.Itoa(nextFD))
+		nextFD++
+	}
+
+	args = append(args, "fetcher")
+	for _, conn := range conns {
+		files = append(files, conn)
+		args = append(args, "--fds="+strconv.Itoa(nextFD))
+		nextFD++
+	}
+
+	binPath := specutils.ExePath
+	cmd := exec.Command(binPath, args...)
+	cmd.ExtraFiles = files
+	cmd.Args[0] = "runsc-fetcher"
+	cmd.SysProcAttr = &syscall.SysProcAttr{}
+	if attached {
+		cmd.SysProcAttr.Pdeathsig = syscall.SIGKILL
+	}
+	if os.Geteuid() == 0 {
+		const nobody = 65534
+		cmd.SysProcAttr.Credential = &syscall.Credential{Uid: nobody, Gid: nobody}
+	}
+
+	log.Debugf("Starting fetcher: %s %v", binPath, args)
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	log.Infof("Fetcher started, PID: %d", cmd.Process.Pid)
+	// Reap the fetcher if this process outlives it.
+	go cmd.Wait()
+	return nil
+}
+
+// connectVirtioFS returns a connection to the vhost-user socket at path.
+func connectVirtioFS(path string) (*os.File, error) {
+	fd, err := syscall.Socket(syscall.AF_UNIX, syscall.SOCK_STREAM|syscall.SOCK_CLOEXEC, 0)
+	if err != nil {
+		return nil, err
+	}
+	if err := syscall.Connect(fd, &syscall.SockaddrUnix{Name: path}); err != nil {
+		syscall.Close(fd)
+		return nil, err
+	}
+	return os.NewFile(uintptr(fd), "sandbox virtiofs FD"), nil
+}
+
+// connectNFS returns a TCP connection to the NFS server at addr. The standard
+// NFS port is used if addr doesn't specify one.
+func connectNFS(addr string) (*os.File, error) {
+	if _, _, err := net.SplitHostPort(addr); err != nil {
+		addr = net.JoinHostPort(strings.Trim(addr, "[]"), "2049")
+	}
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	// File returns a blocking duplicate of the connection's FD.
+	return conn.(*net.TCPConn).File()
+}
+
 // changeStatus transitions from one status to another ensuring that the
 // transition is valid.
 func (c *Container) changeStatus(s Status) {
@@ -1015,6 +1464,12 @@ func runInCgroup(cg *cgroup.Cgroup, fn func() error) error {
 	return fn()
 }
 
+// isCgroupPermissionError returns true if err is caused by the lack of
+// permission to configure cgroups.
+func isCgroupPermissionError(err error) bool {
+	return errors.Is(err, syscall.EACCES) || errors.Is(err, syscall.EPERM) || errors.Is(err, syscall.EROFS)
+}
+
 // adjustGoferOOMScoreAdj sets the oom_store_adj for the container's gofer.
 func (c *Container) adjustGoferOOMScoreAdj() error {
 	if c.GoferPid == 0 || c.Spec.Process.OOMScoreAdj == nil {
@@ -44,6 +44,7 @@ import (
 	"gvisor.dev/gvisor/pkg/urpc"
 	"gvisor.dev/gvisor/runsc/boot/platforms"
 	"gvisor.dev/gvisor/runsc/config"
+	"gvisor.dev/gvisor/runsc/sandbox"
 	"gvisor.dev/gvisor/runsc/specutils"
 )
 
@@ -988,7 +989,7 @@ func TestCheckpointRestore(t *testing.T) {
 			}
 
 			// Checkpoint running container; save state into new file.
-			if err := cont.Checkpoint(file); err != nil {
+			if err := cont.Checkpoint(file, sandbox.CheckpointOpts{}); err != nil {
 				t.Fatalf("error checkpointing container to empty file: %v", err)
 			}
 			defer os.RemoveAll(imagePath)
@@ -1088,6 +1089,205 @@ func TestCheckpointRestore(t *testing.T) {
 	}
 }
 
+// TestCheckpointRestoreStream checks that a container can be checkpointed to
+// a pipe and restored from one, as with "runsc checkpoint --image-fd" and
+// "runsc restore --image-fd".
+func TestCheckpointRestoreStream(t *testing.T) {
+	for name, conf := range configs(t, noOverlay...) {
+		t.Run(name, func(t *testing.T) {
+			dir, err := ioutil.TempDir(testutil.TmpDir(), "checkpoint-test")
+			if err != nil {
+				t.Fatalf("ioutil.TempDir failed: %v", err)
+			}
+			defer os.RemoveAll(dir)
+			if err := os.Chmod(dir, 0777); err != nil {
+				t.Fatalf("error chmoding file: %q, %v", dir, err)
+			}
+
+			outputPath := filepath.Join(dir, "output")
+			outputFile, err := createWriteableOutputFile(outputPath)
+			if err != nil {
+				t.Fatalf("error creating output file: %v", err)
+			}
+			defer outputFile.Close()
+
+			script := fmt.Sprintf("for ((i=0; ;i++)); do echo $i >> %q; sleep 1; done", outputPath)
+			spec := testutil.NewSpecWithArgs("bash", "-c", script)
+			_, bundleDir, cleanup, err := testutil.SetupContainer(spec, conf)
+			if err != nil {
+				t.Fatalf("error setting up container: %v", err)
+			}
+			defer cleanup()
+
+			args := Args{
+				ID:        testutil.RandomContainerID(),
+				Spec:      spec,
+				BundleDir: bundleDir,
+			}
+			cont, err := New(conf, args)
+			if err != nil {
+				t.Fatalf("error creating container: %v", err)
+			}
+			defer cont.Destroy()
+			if err := cont.Start(conf); err != nil {
+				t.Fatalf("error starting container: %v", err)
+			}
+			if err := waitForFileNotEmpty(outputFile); err != nil {
+				t.Fatalf("Failed to wait for output file: %v", err)
+			}
+
+			// Checkpoint into a pipe. The image is buffered in memory, so
+			// that the checkpointed container is gone before it is
+			// restored.
+			var image bytes.Buffer
+			pr, pw, err := os.Pipe()
+			if err != nil {
+				t.Fatalf("os.Pipe failed: %v", err)
+			}
+			copied := make(chan error, 1)
+			go func() {
+				_, err := io.Copy(&image, pr)
+				pr.Close()
+				copied <- err
+			}()
+			err = cont.Checkpoint(pw, sandbox.CheckpointOpts{})
+			pw.Close()
+			if err != nil {
+				t.Fatalf("error checkpointing container to pipe: %v", err)
+			}
+			if err := <-copied; err != nil {
+				t.Fatalf("error reading image from pipe: %v", err)
+			}
+			if image.Len() == 0 {
+				t.Fatalf("checkpoint wrote an empty image")
+			}
+
+			lastNum, err := readOutputNum(outputPath, -1)
+			if err != nil {
+				t.Fatalf("error with outputFile: %v", err)
+			}
+
+			// Delete and recreate file before restoring.
+			if err := os.Remove(outputPath); err != nil {
+				t.Fatalf("error removing file")
+			}
+			outputFile2, err := createWriteableOutputFile(outputPath)
+			if err != nil {
+				t.Fatalf("error creating output file: %v", err)
+			}
+			defer outputFile2.Close()
+
+			// Restore from a pipe, which passes the empty image check
+			// since only regular files are checked.
+			pr2, pw2, err := os.Pipe()
+			if err != nil {
+				t.Fatalf("os.Pipe failed: %v", err)
+			}
+			defer pr2.Close()
+			go func() {
+				io.Copy(pw2, &image)
+				pw2.Close()
+			}()
+
+			args2 := Args{
+				ID:        testutil.RandomContainerID(),
+				Spec:      spec,
+				BundleDir: bundleDir,
+			}
+			cont2, err := New(conf, args2)
+			if err != nil {
+				t.Fatalf("error creating container: %v", err)
+			}
+			defer cont2.Destroy()
+			if err := cont2.Restore(spec, conf, fmt.Sprintf("/proc/self/fd/%d", pr2.Fd())); err != nil {
+				t.Fatalf("error restoring container from pipe: %v", err)
+			}
+
+			if err := waitForFileNotEmpty(outputFile2); err != nil {
+				t.Fatalf("Failed to wait for output file: %v", err)
+			}
+			firstNum, err := readOutputNum(outputPath, 0)
+			if err != nil {
+				t.Fatalf("error with outputFile: %v", err)
+			}
+			if lastNum+1 != firstNum {
+				t.Errorf("error numbers not in order, previous: %d, next: %d", lastNum, firstNum)
+			}
+		})
+	}
+}
+
+// TestRestoreEmptyImage checks that restoring from an empty image file fails
+// before the image is read, while an empty pipe is only found to be empty as
+// it is read.
+func TestRestoreEmptyImage(t *testing.T) {
+	conf := testutil.TestConfig(t)
+	dir, err := ioutil.TempDir(testutil.TmpDir(), "checkpoint-test")
+	if err != nil {
+		t.Fatalf("ioutil.TempDir failed: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	emptyPath := filepath.Join(dir, "empty-image")
+	empty, err := os.Create(emptyPath)
+	if err != nil {
+		t.Fatalf("error creating image file: %v", err)
+	}
+	empty.Close()
+
+	pr, pw, err := os.Pipe()
+	if err != nil {
+		t.Fatalf("os.Pipe failed: %v", err)
+	}
+	defer pr.Close()
+	pw.Close()
+
+	for _, tc := range []struct {
+		name      string
+		image     string
+		wantEmpty bool
+	}{
+		{
+			name:      "file",
+			image:     emptyPath,
+			wantEmpty: true,
+		},
+		{
+			name:      "pipe",
+			image:     fmt.Sprintf("/proc/self/fd/%d", pr.Fd()),
+			wantEmpty: false,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			spec := testutil.NewSpecWithArgs("sleep", "1000")
+			_, bundleDir, cleanup, err := testutil.SetupContainer(spec, conf)
+			if err != nil {
+				t.Fatalf("error setting up container: %v", err)
+			}
+			defer cleanup()
+
+			args := Args{
+				ID:        testutil.RandomContainerID(),
+				Spec:      spec,
+				BundleDir: bundleDir,
+			}
+			cont, err := New(conf, args)
+			if err != nil {
+				t.Fatalf("error creating container: %v", err)
+			}
+			defer cont.Destroy()
+
+			err = cont.Restore(spec, conf, tc.image)
+			if err == nil {
+				t.Fatalf("restore from an empty image succeeded")
+			}
+			if got := strings.Contains(err.Error(), "file cannot be empty"); got != tc.wantEmpty {
+				t.Errorf("restore got error %q, want empty image error: %t", err, tc.wantEmpty)
+			}
+		})
+	}
+}
+
 // TestUnixDomainSockets checks that Checkpoint/Restore works in cases
 // with filesystem Unix Domain Socket use.
 func TestUnixDomainSockets(t *testing.T) {
@@ -1168,7 +1368,7 @@ func TestUnixDomainSockets(t *testing.T) {
 			}
 
 			// Checkpoint running container; save state into new file.
-			if err := cont.Checkpoint(file); err != nil {
+			if err := cont.Checkpoint(file, sandbox.CheckpointOpts{}); err != nil {
 				t.Fatalf("error checkpointing container to empty file: %v", err)
 			}
 
@@ -1968,6 +2168,55 @@ func TestWaitOnExitedSandbox(t *testing.T) {
 	}
 }
 
+// Test that the exit status of the sandbox is available in podman mode to
+// callers that aren't the parent of the sandbox process, e.g. after conmon
+// reaps it.
+func TestPodmanWaitFromNonParent(t *testing.T) {
+	conf := testutil.TestConfig(t)
+	conf.Podman = true
+
+	const wantExit = 17
+	spec := testutil.NewSpecWithArgs("/bin/sh", "-c", fmt.Sprintf("exit %d", wantExit))
+	_, bundleDir, cleanup, err := testutil.SetupContainer(spec, conf)
+	if err != nil {
+		t.Fatalf("error setting up container: %v", err)
+	}
+	defer cleanup()
+
+	args := Args{
+		ID:        testutil.RandomContainerID(),
+		Spec:      spec,
+		BundleDir: bundleDir,
+	}
+	c, err := New(conf, args)
+	if err != nil {
+		t.Fatalf("error creating container: %v", err)
+	}
+	defer c.Destroy()
+	if err := c.Start(conf); err != nil {
+		t.Fatalf("error starting container: %v", err)
+	}
+	if ws, err := c.Wait(); err != nil {
+		t.Fatalf("error waiting on container: %v", err)
+	} else if got := ws.ExitStatus(); got != wantExit {
+		t.Fatalf("got exit status %d, want %d", got, wantExit)
+	}
+
+	// The sandbox process has been reaped. A container loaded from the state
+	// file isn't its parent and must get the status from the exit file.
+	loaded, err := Load(conf.RootDir, FullID{ContainerID: c.ID}, LoadOpts{Exact: true})
+	if err != nil {
+		t.Fatalf("error loading container: %v", err)
+	}
+	ws, err := loaded.Wait()
+	if err != nil {
+		t.Fatalf("error waiting on loaded container: %v", err)
+	}
+	if got := ws.ExitStatus(); got != wantExit {
+		t.Errorf("got exit status %d, want %d", got, wantExit)
+	}
+}
+
 func TestDestroyNotStarted(t *testing.T) {
 	doDestroyNotStartedTest(t, false)
 }
@@ -2057,6 +2306,179 @@ func doDestroyStartingTest(t *testing.T, vfs2 bool) {
 	}
 }
 
+// startWithHook creates a container that runs a shell hook with the given
+// script and timeout in its namespaces, and returns it.
+func startWithHook(t *testing.T, conf *config.Config, script string, timeout *int) (*Container, func()) {
+	t.Helper()
+	spec := testutil.NewSpecWithArgs("/bin/sleep", "100")
+	spec.Hooks = &specs.Hooks{
+		StartContainer: []specs.Hook{{
+			Path:    "/bin/sh",
+			Args:    []string{"sh", "-c", script},
+			Timeout: timeout,
+		}},
+	}
+	_, bundleDir, cleanup, err := testutil.SetupContainer(spec, conf)
+	if err != nil {
+		t.Fatalf("error setting up container: %v", err)
+	}
+	args := Args{
+		ID:        testutil.RandomContainerID(),
+		Spec:      spec,
+		BundleDir: bundleDir,
+	}
+	c, err := New(conf, args)
+	if err != nil {
+		cleanup()
+		t.Fatalf("error creating container: %v", err)
+	}
+	return c, func() {
+		c.Destroy()
+		cleanup()
+	}
+}
+
+// TestContainerHookFails checks that a failing startContainer hook makes start
+// fail with the hook's output.
+func TestContainerHookFails(t *testing.T) {
+	for name, conf := range configsWithVFS2(t, all...) {
+		t.Run(name, func(t *testing.T) {
+			c, cleanup := startWithHook(t, conf, "echo hook output >&2; exit 1", nil)
+			defer cleanup()
+
+			err := c.Start(conf)
+			if err == nil {
+				t.Fatalf("starting container with failing hook succeeded")
+			}
+			if !strings.Contains(err.Error(), "hook output") {
+				t.Errorf("start error doesn't include the hook output: %v", err)
+			}
+		})
+	}
+}
+
+// TestContainerHookTimeout checks that a hook that runs past its timeout is
+// killed, and makes start fail.
+func TestContainerHookTimeout(t *testing.T) {
+	for name, conf := range configsWithVFS2(t, all...) {
+		t.Run(name, func(t *testing.T) {
+			timeout := 1
+			c, cleanup := startWithHook(t, conf, "sleep 1000", &timeout)
+			defer cleanup()
+
+			err := c.Start(conf)
+			if err == nil {
+				t.Fatalf("starting container with hook that times out succeeded")
+			}
+			if !strings.Contains(err.Error(), "timeout") {
+				t.Errorf("start error isn't a timeout: %v", err)
+			}
+		})
+	}
+}
+
+// TestContainerHookKill checks that a container whose hook never exits can
+// still be killed.
+func TestContainerHookKill(t *testing.T) {
+	conf := testutil.TestConfig(t)
+	c, cleanup := startWithHook(t, conf, "sleep 1000", nil)
+	defer cleanup()
+
+	// Container is not thread safe, so load another instance to start.
+	startCont, err := Load(conf.RootDir, FullID{ContainerID: c.ID}, LoadOpts{})
+	if err != nil {
+		t.Fatalf("error loading container: %v", err)
+	}
+	startErr := make(chan error, 1)
+	go func() {
+		startErr <- startCont.Start(conf)
+	}()
+
+	// Wait for the hook to run along with the stopped init process.
+	cb := func() error {
+		pss, err := c.Sandbox.Processes(c.ID)
+		if err != nil {
+			return err
+		}
+		if got := len(pss); got != 2 {
+			return fmt.Errorf("wrong process count, got: %d, want: 2", got)
+		}
+		return nil
+	}
+	if err := testutil.Poll(cb, 30*time.Second); err != nil {
+		t.Fatalf("waiting for hook to start: %v", err)
+	}
+
+	if err := c.Sandbox.SignalContainer(c.ID, syscall.SIGKILL, true); err != nil {
+		t.Fatalf("killing container: %v", err)
+	}
+	select {
+	case err := <-startErr:
+		if err == nil {
+			t.Errorf("starting killed container succeeded")
+		}
+	case <-time.After(30 * time.Second):
+		t.Fatalf("start didn't return after the container was killed")
+	}
+}
+
+// TestContainerHookNotInRoot checks that createContainer hooks are resolved on
+// the host when the container is created, and that startContainer hooks are
+// resolved in the container's root, so start fails if the hook isn't there.
+func TestContainerHookNotInRoot(t *testing.T) {
+	for name, conf := range configsWithVFS2(t, all...) {
+		t.Run(name, func(t *testing.T) {
+			dir, err := ioutil.TempDir(testutil.TmpDir(), "hook")
+			if err != nil {
+				t.Fatalf("ioutil.TempDir() failed: %v", err)
+			}
+			defer os.RemoveAll(dir)
+			hook := filepath.Join(dir, "hook.sh")
+			ran := filepath.Join(dir, "ran")
+			if err := ioutil.WriteFile(hook, []byte("#!/bin/sh\ntouch "+ran+"\n"), 0755); err != nil {
+				t.Fatalf("ioutil.WriteFile() failed: %v", err)
+			}
+
+			spec := testutil.NewSpecWithArgs("/bin/true")
+			// Hide the hook from the container.
+			spec.Mounts = append(spec.Mounts, specs.Mount{
+				Type:        "tmpfs",
+				Destination: dir,
+			})
+			spec.Hooks = &specs.Hooks{
+				CreateContainer: []specs.Hook{{Path: hook}},
+				StartContainer:  []specs.Hook{{Path: hook}},
+			}
+			_, bundleDir, cleanup, err := testutil.SetupContainer(spec, conf)
+			if err != nil {
+				t.Fatalf("error setting up container: %v", err)
+			}
+			defer cleanup()
+			args := Args{
+				ID:        testutil.RandomContainerID(),
+				Spec:      spec,
+				BundleDir: bundleDir,
+			}
+			c, err := New(conf, args)
+			if err != nil {
+				t.Fatalf("error creating container: %v", err)
+			}
+			defer c.Destroy()
+
+			if _, err := os.Stat(ran); err != nil {
+				t.Errorf("createContainer hook didn't run on create: %v", err)
+			}
+			err = c.Start(conf)
+			if err == nil {
+				t.Fatalf("starting container with startContainer hook missing from its root succeeded")
+			}
+			if !strings.Contains(err.Error(), hook) {
+				t.Errorf("start error doesn't include the hook path: %v", err)
+			}
+		})
+	}
+}
+
 func TestCreateWorkingDir(t *testing.T) {
 	for name, conf := range configsWithVFS2(t, all...) {
 		t.Run(name, func(t *testing.T) {
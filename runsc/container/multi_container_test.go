@@ -1379,6 +1379,74 @@ func TestMultiContainerSharedMountUnsupportedOptions(t *testing.T) {
 	}
 }
 
+// Test that the /dev/shm set by the pod's shm size annotation is shared by all
+// containers, and that its size limit applies to the pod as a whole.
+func TestMultiContainerSharedShm(t *testing.T) {
+	for name, conf := range configs(t, all...) {
+		t.Run(name, func(t *testing.T) {
+			rootDir, cleanup, err := testutil.SetupRootDir()
+			if err != nil {
+				t.Fatalf("error creating root dir: %v", err)
+			}
+			defer cleanup()
+			conf.RootDir = rootDir
+			conf.VFS2 = true
+
+			// Setup the containers.
+			sleep := []string{"sleep", "100"}
+			podSpec, ids := createSpecs(sleep, sleep)
+			for _, spec := range podSpec {
+				spec.Annotations[boot.ShmSizeAnnotation] = "1m"
+			}
+
+			containers, cleanup, err := startContainers(conf, podSpec, ids)
+			if err != nil {
+				t.Fatalf("error starting containers: %v", err)
+			}
+			defer cleanup()
+
+			// write returns a command that writes size bytes to name in /dev/shm.
+			write := func(name string, size int) []string {
+				return []string{"/bin/sh", "-c", fmt.Sprintf("head -c %d /dev/zero > /dev/shm/%s", size, name)}
+			}
+			execs := []execDesc{
+				{
+					c:    containers[0],
+					cmd:  write("abc", 768<<10),
+					name: "write file in container0",
+				},
+				{
+					c:    containers[1],
+					cmd:  []string{"/usr/bin/test", "-s", "/dev/shm/abc"},
+					name: "file appears in container1",
+				},
+				{
+					c:    containers[1],
+					cmd:  write("def", 512<<10),
+					want: 1,
+					name: "write beyond pod limit fails in container1",
+				},
+				{
+					c:    containers[1],
+					cmd:  []string{"/bin/rm", "/dev/shm/abc", "/dev/shm/def"},
+					name: "remove files from container1",
+				},
+				{
+					c:    containers[0],
+					cmd:  []string{"/usr/bin/test", "!", "-f", "/dev/shm/abc"},
+					name: "file removed from container0",
+				},
+				{
+					c:    containers[1],
+					cmd:  write("def", 512<<10),
+					name: "write within pod limit succeeds in container1",
+				},
+			}
+			execMany(t, execs)
+		})
+	}
+}
+
 // Test that one container can send an FD to another container, even though
 // they have distinct MountNamespaces.
 func TestMultiContainerMultiRootCanHandleFDs(t *testing.T) {
@@ -1783,8 +1851,8 @@ func TestMultiContainerEvent(t *testing.T) {
 		if err := json.Unmarshal(data, &stats); err != nil {
 			t.Fatalf("invalid event data: %v", err)
 		}
-		// One process per remaining container.
-		if want := uint64(2); stats.Pids.Current != want {
+		// Stats only count the container's own process.
+		if want := uint64(1); stats.Pids.Current != want {
 			t.Errorf("Wrong number of PIDs, want: %d, got :%d", want, stats.Pids.Current)
 		}
 	}
@@ -1885,3 +1953,78 @@ func TestDuplicateEnvVariable(t *testing.T) {
 		}
 	}
 }
+
+// Tests that the resources of a sandbox are the sums of those of its
+// application containers.
+func TestSandboxResources(t *testing.T) {
+	withLimits := func(quota, period int64, mem int64) *specs.Spec {
+		p := uint64(period)
+		return &specs.Spec{
+			Linux: &specs.Linux{
+				Resources: &specs.LinuxResources{
+					CPU:    &specs.LinuxCPU{Quota: &quota, Period: &p},
+					Memory: &specs.LinuxMemory{Limit: &mem},
+				},
+			},
+		}
+	}
+	pause := &specs.Spec{
+		Annotations: map[string]string{
+			specutils.ContainerdContainerTypeAnnotation: specutils.ContainerdContainerTypeSandbox,
+		},
+	}
+
+	for _, tc := range []struct {
+		name       string
+		containers []*Container
+		cpus       float64
+		mem        int64
+	}{
+		{
+			name: "single",
+			containers: []*Container{
+				{Spec: withLimits(150000, 100000, 1<<30), Status: Running},
+			},
+			cpus: 1.5,
+			mem:  1 << 30,
+		},
+		{
+			name: "pod",
+			containers: []*Container{
+				{Spec: pause, Status: Running},
+				{Spec: withLimits(50000, 100000, 1<<30), Status: Running},
+				{Spec: withLimits(200000, 200000, 2<<30), Status: Running},
+				{Spec: withLimits(100000, 100000, 4<<30), Status: Stopped},
+			},
+			cpus: 1.5,
+			mem:  3 << 30,
+		},
+		{
+			name: "unlimited",
+			containers: []*Container{
+				{Spec: pause, Status: Running},
+				{Spec: withLimits(50000, 100000, 1<<30), Status: Running},
+				{Spec: &specs.Spec{}, Status: Running},
+			},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			res := sandboxResources(tc.containers)
+			if tc.cpus == 0 {
+				if res.CPU != nil || res.Memory != nil {
+					t.Errorf("got resources %+v, want none", res)
+				}
+				return
+			}
+			if res.CPU == nil || res.Memory == nil {
+				t.Fatalf("got resources %+v, want CPU and memory limits", res)
+			}
+			if got := float64(*res.CPU.Quota) / float64(*res.CPU.Period); math.Abs(got-tc.cpus) > 1e-9 {
+				t.Errorf("got %v CPUs, want %v", got, tc.cpus)
+			}
+			if got := *res.Memory.Limit; got != tc.mem {
+				t.Errorf("got memory limit %d, want %d", got, tc.mem)
+			}
+		})
+	}
+}
@@ -343,6 +343,12 @@ func (s *StateFile) lockPath() string {
 	return buildPath(s.RootDir, s.ID, "lock")
 }
 
+// exitPath is the full path to the file that holds the exit status of the
+// sandbox, if the sandbox saves it.
+func (s *StateFile) exitPath() string {
+	return buildPath(s.RootDir, s.ID, "exit")
+}
+
 // destroy deletes all state created by the stateFile. It may be called with the
 // lock file held. In that case, the lock file must still be unlocked and
 // properly closed after destroy returns.
@@ -353,5 +359,8 @@ func (s *StateFile) destroy() error {
 	if err := os.Remove(s.lockPath()); err != nil && !os.IsNotExist(err) {
 		return err
 	}
+	if err := os.Remove(s.exitPath()); err != nil && !os.IsNotExist(err) {
+		return err
+	}
 	return nil
 }
@@ -0,0 +1,404 @@
+// Copyright 2021 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package controlapi provides the endpoints of the runsc control API, a gRPC
+// service defined in control.proto that lets external agents manage the
+// sandboxes of a runsc root directory.
+//
+// The API is served on a Unix domain socket or a TCP address. Unix sockets
+// only accept connections from the user running the server and from
+// explicitly allowed users. TCP endpoints require TLS, and clients must
+// present either a certificate signed by a trusted CA (mutual TLS) or a bearer
+// token.
+package controlapi
+
+import (
+	"bytes"
+	"context"
+	"crypto/subtle"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"strings"
+
+	"golang.org/x/sys/unix"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"gvisor.dev/gvisor/pkg/log"
+)
+
+// APIVersion is the version of the control API, which matches the version in
+// its proto package.
+const APIVersion = "v1"
+
+// minTokenSize is the minimum size of a bearer token.
+const minTokenSize = 16
+
+// authorizationKey is the gRPC metadata key that holds the bearer token.
+const authorizationKey = "authorization"
+
+// ServerOptions configures the endpoint of a control API server.
+type ServerOptions struct {
+	// Address is the address on which the server listens, either
+	// "unix:<path>" or "tcp:<host>:<port>".
+	Address string
+
+	// CertFile and KeyFile hold the TLS certificate and key of the server.
+	// TLS is required on TCP endpoints and optional on Unix ones.
+	CertFile string
+	KeyFile  string
+
+	// ClientCAFile, if set, holds the CA certificates that sign client
+	// certificates. Clients must then present such a certificate.
+	ClientCAFile string
+
+	// Token, if set, must be presented by clients as a bearer token.
+	Token []byte
+
+	// AllowedUIDs are the users, in addition to the user running the server,
+	// that may connect to a Unix endpoint.
+	AllowedUIDs []uint32
+}
+
+// ClientOptions configures the connection of a client to a control API
+// server.
+type ClientOptions struct {
+	// Address is the address of the server, as in ServerOptions.
+	Address string
+
+	// CAFile, if set, holds the CA certificates that sign the server's
+	// certificate, and enables TLS.
+	CAFile string
+
+	// ServerName is the name expected in the server's certificate. It
+	// defaults to the host of Address.
+	ServerName string
+
+	// CertFile and KeyFile, if set, hold the client's certificate and key for
+	// mutual TLS.
+	CertFile string
+	KeyFile  string
+
+	// Token, if set, is presented to the server as a bearer token.
+	Token []byte
+}
+
+// parseAddress splits an address into a network and an address for
+// net.Listen and net.Dial.
+func parseAddress(addr string) (string, string, error) {
+	i := strings.Index(addr, ":")
+	if i < 0 {
+		return "", "", fmt.Errorf("address %q has no network, want \"unix:<path>\" or \"tcp:<host>:<port>\"", addr)
+	}
+	network, rest := addr[:i], addr[i+1:]
+	if rest == "" {
+		return "", "", fmt.Errorf("address %q is empty", addr)
+	}
+	switch network {
+	case "unix", "tcp":
+		return network, rest, nil
+	default:
+		return "", "", fmt.Errorf("address %q has unsupported network %q, want \"unix\" or \"tcp\"", addr, network)
+	}
+}
+
+// ReadToken reads a bearer token from a file. Trailing newlines are removed.
+func ReadToken(path string) ([]byte, error) {
+	token, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	token = bytes.TrimRight(token, "\r\n")
+	if len(token) < minTokenSize {
+		return nil, fmt.Errorf("token in %q is %d bytes, must be at least %d", path, len(token), minTokenSize)
+	}
+	return token, nil
+}
+
+// NewServer returns a gRPC server that authenticates clients as configured by
+// opts, and the listener on which it must serve. The caller registers the
+// control service with the server and then calls Serve.
+func NewServer(opts ServerOptions) (*grpc.Server, net.Listener, error) {
+	network, addr, err := parseAddress(opts.Address)
+	if err != nil {
+		return nil, nil, err
+	}
+	if network == "tcp" {
+		if opts.CertFile == "" {
+			return nil, nil, fmt.Errorf("TCP endpoints require a TLS certificate")
+		}
+		if opts.ClientCAFile == "" && len(opts.Token) == 0 {
+			return nil, nil, fmt.Errorf("TCP endpoints require client certificates or a token")
+		}
+	}
+	if opts.ClientCAFile != "" && opts.CertFile == "" {
+		return nil, nil, fmt.Errorf("client certificates require a TLS certificate")
+	}
+
+	var serverOpts []grpc.ServerOption
+	if opts.CertFile != "" {
+		cfg, err := serverTLSConfig(opts)
+		if err != nil {
+			return nil, nil, err
+		}
+		serverOpts = append(serverOpts, grpc.Creds(credentials.NewTLS(cfg)))
+	}
+	if len(opts.Token) > 0 {
+		a := &tokenAuthenticator{token: opts.Token}
+		serverOpts = append(serverOpts, grpc.UnaryInterceptor(a.unary), grpc.StreamInterceptor(a.stream))
+	}
+
+	var l net.Listener
+	switch network {
+	case "unix":
+		l, err = listenUnix(addr, opts.AllowedUIDs)
+	case "tcp":
+		l, err = net.Listen(network, addr)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	return grpc.NewServer(serverOpts...), l, nil
+}
+
+// serverTLSConfig returns the TLS configuration of a server.
+func serverTLSConfig(opts ServerOptions) (*tls.Config, error) {
+	cert, err := tls.LoadX509KeyPair(opts.CertFile, opts.KeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("loading TLS certificate: %v", err)
+	}
+	cfg := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}
+	if opts.ClientCAFile != "" {
+		pool, err := loadCertPool(opts.ClientCAFile)
+		if err != nil {
+			return nil, err
+		}
+		cfg.ClientCAs = pool
+		cfg.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	return cfg, nil
+}
+
+// loadCertPool returns a pool of the PEM certificates in path.
+func loadCertPool(path string) (*x509.CertPool, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(b) {
+		return nil, fmt.Errorf("no certificates found in %q", path)
+	}
+	return pool, nil
+}
+
+// listenUnix listens on a Unix domain socket at path, accepting connections
+// from the user running the server and the given users. A stale socket at
+// path is replaced.
+func listenUnix(path string, allowedUIDs []uint32) (net.Listener, error) {
+	if fi, err := os.Lstat(path); err == nil {
+		if fi.Mode()&os.ModeSocket == 0 {
+			return nil, fmt.Errorf("%q exists and isn't a socket", path)
+		}
+		if err := os.Remove(path); err != nil {
+			return nil, fmt.Errorf("removing stale socket: %v", err)
+		}
+	}
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	// Other allowed users must be able to open the socket. Either way, peer
+	// credentials are checked on every connection.
+	mode := os.FileMode(0600)
+	if len(allowedUIDs) > 0 {
+		mode = 0666
+	}
+	if err := os.Chmod(path, mode); err != nil {
+		l.Close()
+		return nil, err
+	}
+	uids := map[uint32]struct{}{uint32(os.Geteuid()): {}}
+	for _, uid := range allowedUIDs {
+		uids[uid] = struct{}{}
+	}
+	return &peerCredListener{Listener: l, uids: uids}, nil
+}
+
+// peerCredListener is a Unix domain socket listener that closes connections
+// from users that aren't allowed.
+type peerCredListener struct {
+	net.Listener
+	uids map[uint32]struct{}
+}
+
+// Accept implements net.Listener.Accept.
+func (l *peerCredListener) Accept() (net.Conn, error) {
+	for {
+		conn, err := l.Listener.Accept()
+		if err != nil {
+			return nil, err
+		}
+		uid, err := peerUID(conn)
+		if err != nil {
+			log.Warningf("Control API: rejecting connection, getting peer credentials: %v", err)
+			conn.Close()
+			continue
+		}
+		if _, ok := l.uids[uid]; !ok {
+			log.Warningf("Control API: rejecting connection from UID %d", uid)
+			conn.Close()
+			continue
+		}
+		return conn, nil
+	}
+}
+
+// peerUID returns the UID of the process at the other end of a Unix domain
+// socket connection.
+func peerUID(conn net.Conn) (uint32, error) {
+	uc, ok := conn.(*net.UnixConn)
+	if !ok {
+		return 0, fmt.Errorf("connection is a %T, not a Unix domain socket", conn)
+	}
+	rc, err := uc.SyscallConn()
+	if err != nil {
+		return 0, err
+	}
+	var (
+		cred    *unix.Ucred
+		credErr error
+	)
+	if err := rc.Control(func(fd uintptr) {
+		cred, credErr = unix.GetsockoptUcred(int(fd), unix.SOL_SOCKET, unix.SO_PEERCRED)
+	}); err != nil {
+		return 0, err
+	}
+	if credErr != nil {
+		return 0, credErr
+	}
+	return cred.Uid, nil
+}
+
+// tokenAuthenticator rejects calls that don't present the bearer token.
+type tokenAuthenticator struct {
+	token []byte
+}
+
+func (a *tokenAuthenticator) check(ctx context.Context) error {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return status.Error(codes.Unauthenticated, "missing token")
+	}
+	for _, v := range md.Get(authorizationKey) {
+		token := strings.TrimPrefix(v, "Bearer ")
+		if subtle.ConstantTimeCompare([]byte(token), a.token) == 1 {
+			return nil
+		}
+	}
+	return status.Error(codes.Unauthenticated, "missing or invalid token")
+}
+
+func (a *tokenAuthenticator) unary(ctx context.Context, req interface{}, _ *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	if err := a.check(ctx); err != nil {
+		return nil, err
+	}
+	return handler(ctx, req)
+}
+
+func (a *tokenAuthenticator) stream(srv interface{}, ss grpc.ServerStream, _ *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	if err := a.check(ss.Context()); err != nil {
+		return err
+	}
+	return handler(srv, ss)
+}
+
+// tokenCredentials implements credentials.PerRPCCredentials to present a
+// bearer token.
+type tokenCredentials struct {
+	token  []byte
+	secure bool
+}
+
+// GetRequestMetadata implements credentials.PerRPCCredentials.GetRequestMetadata.
+func (c *tokenCredentials) GetRequestMetadata(context.Context, ...string) (map[string]string, error) {
+	return map[string]string{authorizationKey: "Bearer " + string(c.token)}, nil
+}
+
+// RequireTransportSecurity implements
+// credentials.PerRPCCredentials.RequireTransportSecurity.
+func (c *tokenCredentials) RequireTransportSecurity() bool {
+	return c.secure
+}
+
+// Dial connects to a control API server.
+func Dial(ctx context.Context, opts ClientOptions) (*grpc.ClientConn, error) {
+	network, addr, err := parseAddress(opts.Address)
+	if err != nil {
+		return nil, err
+	}
+	secure := opts.CAFile != ""
+	dialOpts := []grpc.DialOption{
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, network, addr)
+		}),
+	}
+	if secure {
+		pool, err := loadCertPool(opts.CAFile)
+		if err != nil {
+			return nil, err
+		}
+		cfg := &tls.Config{
+			RootCAs:    pool,
+			ServerName: opts.ServerName,
+			MinVersion: tls.VersionTLS12,
+		}
+		if cfg.ServerName == "" && network == "tcp" {
+			host, _, err := net.SplitHostPort(addr)
+			if err != nil {
+				return nil, err
+			}
+			cfg.ServerName = host
+		}
+		if opts.CertFile != "" {
+			cert, err := tls.LoadX509KeyPair(opts.CertFile, opts.KeyFile)
+			if err != nil {
+				return nil, fmt.Errorf("loading TLS certificate: %v", err)
+			}
+			cfg.Certificates = []tls.Certificate{cert}
+		}
+		dialOpts = append(dialOpts, grpc.WithTransportCredentials(credentials.NewTLS(cfg)))
+	} else {
+		if network == "tcp" {
+			return nil, fmt.Errorf("TCP endpoints require TLS")
+		}
+		dialOpts = append(dialOpts, grpc.WithInsecure())
+	}
+	if len(opts.Token) > 0 {
+		dialOpts = append(dialOpts, grpc.WithPerRPCCredentials(&tokenCredentials{token: opts.Token, secure: secure}))
+	}
+	// The target is only used by the dialer above; "passthrough" keeps gRPC
+	// from resolving it.
+	return grpc.DialContext(ctx, "passthrough:///"+opts.Address, dialOpts...)
+}
@@ -0,0 +1,289 @@
+// Copyright 2021 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package controlapi
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	pb "gvisor.dev/gvisor/runsc/controlapi/control_go_proto"
+)
+
+// fakeControl implements GetVersion of pb.ControlServer.
+type fakeControl struct {
+	pb.UnimplementedControlServer
+}
+
+// GetVersion implements pb.ControlServer.GetVersion.
+func (fakeControl) GetVersion(context.Context, *pb.GetVersionRequest) (*pb.GetVersionResponse, error) {
+	return &pb.GetVersionResponse{ApiVersion: APIVersion}, nil
+}
+
+// serve starts a server with the given options, and returns its address.
+func serve(t *testing.T, opts ServerOptions) string {
+	t.Helper()
+	srv, l, err := NewServer(opts)
+	if err != nil {
+		t.Fatalf("NewServer failed: %v", err)
+	}
+	pb.RegisterControlServer(srv, fakeControl{})
+	go srv.Serve(l)
+	t.Cleanup(srv.Stop)
+	return l.Addr().Network() + ":" + l.Addr().String()
+}
+
+// getVersion calls GetVersion with the given client options.
+func getVersion(t *testing.T, opts ClientOptions) error {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	conn, err := Dial(ctx, opts)
+	if err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+	defer conn.Close()
+	resp, err := pb.NewControlClient(conn).GetVersion(ctx, &pb.GetVersionRequest{})
+	if err == nil && resp.ApiVersion != APIVersion {
+		t.Errorf("GetVersion: got version %q, want %q", resp.ApiVersion, APIVersion)
+	}
+	return err
+}
+
+func TestParseAddress(t *testing.T) {
+	for _, tc := range []struct {
+		addr    string
+		network string
+		address string
+	}{
+		{addr: "unix:/run/runsc.sock", network: "unix", address: "/run/runsc.sock"},
+		{addr: "tcp:localhost:1234", network: "tcp", address: "localhost:1234"},
+		{addr: "tcp:[::1]:1234", network: "tcp", address: "[::1]:1234"},
+	} {
+		network, address, err := parseAddress(tc.addr)
+		if err != nil {
+			t.Errorf("parseAddress(%q) failed: %v", tc.addr, err)
+			continue
+		}
+		if network != tc.network || address != tc.address {
+			t.Errorf("parseAddress(%q): got (%q, %q), want (%q, %q)", tc.addr, network, address, tc.network, tc.address)
+		}
+	}
+
+	for _, addr := range []string{"", "/run/runsc.sock", "unix:", "udp:localhost:1234"} {
+		if _, _, err := parseAddress(addr); err == nil {
+			t.Errorf("parseAddress(%q) succeeded, want error", addr)
+		}
+	}
+}
+
+func TestUnixToken(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "control.sock")
+	token := []byte("0123456789abcdef")
+	addr := serve(t, ServerOptions{Address: "unix:" + path, Token: token})
+
+	fi, err := os.Stat(path)
+	if err != nil {
+		t.Fatalf("Stat failed: %v", err)
+	}
+	if got := fi.Mode().Perm(); got != 0600 {
+		t.Errorf("socket mode: got %#o, want 0600", got)
+	}
+
+	if err := getVersion(t, ClientOptions{Address: addr, Token: token}); err != nil {
+		t.Errorf("GetVersion with token failed: %v", err)
+	}
+	for _, token := range [][]byte{nil, []byte("fedcba9876543210")} {
+		err := getVersion(t, ClientOptions{Address: addr, Token: token})
+		if status.Code(err) != codes.Unauthenticated {
+			t.Errorf("GetVersion with token %q: got error %v, want code %v", token, err, codes.Unauthenticated)
+		}
+	}
+}
+
+func TestUnixPeerCredentials(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "control.sock")
+	srv, l, err := NewServer(ServerOptions{Address: "unix:" + path})
+	if err != nil {
+		t.Fatalf("NewServer failed: %v", err)
+	}
+	defer srv.Stop()
+	pl := l.(*peerCredListener)
+	// Only allow a user other than the test's.
+	pl.uids = map[uint32]struct{}{uint32(os.Geteuid()) + 1: {}}
+	go func() {
+		if conn, err := pl.Accept(); err == nil {
+			t.Errorf("Accept of connection from UID %d succeeded", os.Geteuid())
+			conn.Close()
+		}
+	}()
+	defer pl.Close()
+
+	conn, err := net.Dial("unix", path)
+	if err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+	defer conn.Close()
+	conn.SetReadDeadline(time.Now().Add(10 * time.Second))
+	if n, err := conn.Read(make([]byte, 1)); err == nil {
+		t.Errorf("Read returned %d bytes, want the connection to be closed", n)
+	}
+}
+
+func TestTCPRequiresAuthentication(t *testing.T) {
+	dir := t.TempDir()
+	ca := newCA(t)
+	certFile, keyFile := ca.issue(t, dir, "server")
+	for _, opts := range []ServerOptions{
+		{Address: "tcp:127.0.0.1:0"},
+		{Address: "tcp:127.0.0.1:0", Token: []byte("0123456789abcdef")},
+		{Address: "tcp:127.0.0.1:0", CertFile: certFile, KeyFile: keyFile},
+	} {
+		if _, l, err := NewServer(opts); err == nil {
+			l.Close()
+			t.Errorf("NewServer(%+v) succeeded, want error", opts)
+		}
+	}
+}
+
+func TestMutualTLS(t *testing.T) {
+	dir := t.TempDir()
+	ca := newCA(t)
+	caFile := ca.write(t, dir)
+	serverCert, serverKey := ca.issue(t, dir, "server")
+	clientCert, clientKey := ca.issue(t, dir, "client")
+	addr := serve(t, ServerOptions{
+		Address:      "tcp:127.0.0.1:0",
+		CertFile:     serverCert,
+		KeyFile:      serverKey,
+		ClientCAFile: caFile,
+	})
+
+	opts := ClientOptions{
+		Address:  addr,
+		CAFile:   caFile,
+		CertFile: clientCert,
+		KeyFile:  clientKey,
+	}
+	if err := getVersion(t, opts); err != nil {
+		t.Errorf("GetVersion with client certificate failed: %v", err)
+	}
+
+	// A certificate from another CA is rejected.
+	otherCert, otherKey := newCA(t).issue(t, dir, "other")
+	opts.CertFile, opts.KeyFile = otherCert, otherKey
+	if err := getVersion(t, opts); err == nil {
+		t.Errorf("GetVersion with untrusted client certificate succeeded")
+	}
+
+	opts.CertFile, opts.KeyFile = "", ""
+	if err := getVersion(t, opts); err == nil {
+		t.Errorf("GetVersion without client certificate succeeded")
+	}
+}
+
+// testCA is a certificate authority for tests.
+type testCA struct {
+	cert *x509.Certificate
+	der  []byte
+	key  *ecdsa.PrivateKey
+}
+
+func newCA(t *testing.T) *testCA {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("GenerateKey failed: %v", err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("CreateCertificate failed: %v", err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatalf("ParseCertificate failed: %v", err)
+	}
+	return &testCA{cert: cert, der: der, key: key}
+}
+
+// write writes the certificate of the CA to dir, and returns its path.
+func (ca *testCA) write(t *testing.T, dir string) string {
+	t.Helper()
+	path := filepath.Join(dir, "ca.pem")
+	writePEM(t, path, "CERTIFICATE", ca.der)
+	return path
+}
+
+// issue issues a certificate for 127.0.0.1, usable by clients and servers,
+// and returns the paths of the certificate and its key.
+func (ca *testCA) issue(t *testing.T, dir, name string) (string, string) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("GenerateKey failed: %v", err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1)},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, ca.cert, &key.PublicKey, ca.key)
+	if err != nil {
+		t.Fatalf("CreateCertificate failed: %v", err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatalf("MarshalECPrivateKey failed: %v", err)
+	}
+	certFile := filepath.Join(dir, name+".pem")
+	keyFile := filepath.Join(dir, name+"-key.pem")
+	writePEM(t, certFile, "CERTIFICATE", der)
+	writePEM(t, keyFile, "EC PRIVATE KEY", keyDER)
+	return certFile, keyFile
+}
+
+func writePEM(t *testing.T, path, typ string, der []byte) {
+	t.Helper()
+	b := pem.EncodeToMemory(&pem.Block{Type: typ, Bytes: der})
+	if err := ioutil.WriteFile(path, b, 0600); err != nil {
+		t.Fatalf("WriteFile failed: %v", err)
+	}
+}
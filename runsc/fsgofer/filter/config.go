@@ -73,7 +73,17 @@ var allowedSyscalls = seccomp.SyscallRules{
 			seccomp.MatchAny{},
 			seccomp.EqualTo(unix.F_ADD_SEALS),
 		},
+		// Used by localFile.Lock() and localFile.GetLock().
+		{
+			seccomp.MatchAny{},
+			seccomp.EqualTo(unix.F_OFD_SETLK),
+		},
+		{
+			seccomp.MatchAny{},
+			seccomp.EqualTo(unix.F_OFD_GETLK),
+		},
 	},
+	syscall.SYS_FLOCK:     {},
 	syscall.SYS_FSTAT:     {},
 	syscall.SYS_FSTATFS:   {},
 	syscall.SYS_FSYNC:     {},
@@ -172,6 +182,7 @@ var allowedSyscalls = seccomp.SyscallRules{
 		},
 	},
 	syscall.SYS_RENAMEAT:        {},
+	unix.SYS_RENAMEAT2:          {},
 	syscall.SYS_RESTART_SYSCALL: {},
 	syscall.SYS_RT_SIGPROCMASK:  {},
 	syscall.SYS_RT_SIGRETURN:    {},
@@ -237,3 +248,20 @@ var udsSyscalls = seccomp.SyscallRules{
 		},
 	},
 }
+
+var udsCreateSyscalls = seccomp.SyscallRules{
+	syscall.SYS_BIND:     {},
+	syscall.SYS_FCHMODAT: {},
+	syscall.SYS_LISTEN: []seccomp.Rule{
+		{
+			seccomp.MatchAny{},
+			seccomp.EqualTo(128 /* fsgofer.hostUDSBacklog */),
+		},
+	},
+}
+
+var invalidationsSyscalls = seccomp.SyscallRules{
+	syscall.SYS_FCHDIR:            {},
+	syscall.SYS_INOTIFY_ADD_WATCH: {},
+	syscall.SYS_INOTIFY_RM_WATCH:  {},
+}
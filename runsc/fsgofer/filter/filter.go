@@ -36,3 +36,15 @@ func InstallUDSFilters() {
 	// Add additional filters required for connecting to the host's sockets.
 	allowedSyscalls.Merge(udsSyscalls)
 }
+
+// InstallUDSCreateFilters extends the allowed syscalls to include those
+// necessary for creating host UDS files that the sandbox listens on.
+func InstallUDSCreateFilters() {
+	allowedSyscalls.Merge(udsCreateSyscalls)
+}
+
+// InstallInvalidationsFilters extends the allowed syscalls to include those
+// necessary for reporting changes made to files with inotify.
+func InstallInvalidationsFilters() {
+	allowedSyscalls.Merge(invalidationsSyscalls)
+}
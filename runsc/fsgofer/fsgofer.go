@@ -66,6 +66,14 @@ type Config struct {
 
 	// HostUDS signals whether the gofer can mount a host's UDS.
 	HostUDS bool
+
+	// HostUDSCreate signals whether the gofer can create host UDS files on
+	// which the sandbox listens. It requires HostUDS.
+	HostUDSCreate bool
+
+	// Invalidations signals whether the gofer reports changes made to files
+	// with inotify, letting clients cache file metadata coherently.
+	Invalidations bool
 }
 
 type attachPoint struct {
@@ -85,6 +93,10 @@ type attachPoint struct {
 	// devices is a map from actual host devices to "small" integers that
 	// can be combined with host inode to form a unique virtual inode id.
 	devices map[uint64]uint8
+
+	// watcher reports changes made to files if Config.Invalidations is set.
+	// It is immutable.
+	watcher *invalidationWatcher
 }
 
 // NewAttachPoint creates a new attacher that gives local file
@@ -94,11 +106,20 @@ func NewAttachPoint(prefix string, c Config) (p9.Attacher, error) {
 	if !filepath.IsAbs(prefix) {
 		return nil, fmt.Errorf("attach point prefix must be absolute %q", prefix)
 	}
-	return &attachPoint{
+	a := &attachPoint{
 		prefix:  prefix,
 		conf:    c,
 		devices: make(map[uint64]uint8),
-	}, nil
+	}
+	if !c.Invalidations {
+		return a, nil
+	}
+	w, err := newInvalidationWatcher()
+	if err != nil {
+		return nil, err
+	}
+	a.watcher = w
+	return invalidatingAttachPoint{a}, nil
 }
 
 // Attach implements p9.Attacher.
@@ -227,6 +248,10 @@ type localFile struct {
 	// repositioned. This is an important optimization because the caller must
 	// always make one extra call to detect EOF (empty result, no error).
 	lastDirentOffset uint64
+
+	// wd is the inotify watch descriptor reporting changes to the file, or 0
+	// if it isn't watched.
+	wd int32
 }
 
 var procSelfFD *fd.FD
@@ -325,7 +350,7 @@ func newLocalFile(a *attachPoint, file *fd.FD, path string, readable bool, stat
 		return nil, err
 	}
 
-	return &localFile{
+	l := &localFile{
 		attachPoint:     a,
 		hostPath:        path,
 		file:            file,
@@ -333,7 +358,28 @@ func newLocalFile(a *attachPoint, file *fd.FD, path string, readable bool, stat
 		fileType:        stat.Mode & unix.S_IFMT,
 		qid:             a.makeQID(stat),
 		controlReadable: readable,
-	}, nil
+	}
+	if err := l.startWatching(stat); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+// startWatching starts reporting changes to l if invalidations are enabled.
+// stat is refreshed in this case, as changes made before may not be reported.
+func (l *localFile) startWatching(stat *unix.Stat_t) error {
+	if l.attachPoint.watcher == nil {
+		return nil
+	}
+	if l.wd = l.attachPoint.watcher.add(l); l.wd == 0 {
+		return nil
+	}
+	if err := unix.Fstat(l.file.FD(), stat); err != nil {
+		l.attachPoint.watcher.remove(l.wd)
+		l.wd = 0
+		return err
+	}
+	return nil
 }
 
 // newFDMaybe creates a fd.FD from a file, dup'ing the FD and setting it as
@@ -484,6 +530,46 @@ func (l *localFile) Create(name string, p9Flags p9.OpenFlags, perm p9.FileMode,
 	return newFDMaybe(c.file), c, c.qid, 0, nil
 }
 
+// CreateTmpfile implements p9.TmpfileCreator.
+func (l *localFile) CreateTmpfile(p9Flags p9.OpenFlags, perm p9.FileMode, uid p9.UID, gid p9.GID) (*fd.FD, p9.File, p9.QID, uint32, error) {
+	if err := l.checkROMount(); err != nil {
+		return nil, nil, p9.QID{}, 0, err
+	}
+
+	osFlags := openFlags | unix.O_TMPFILE
+	if p9Flags&p9.OpenExclusive != 0 {
+		osFlags |= unix.O_EXCL
+	}
+
+	// As in Create, read access is always added to flags. O_TMPFILE requires
+	// write access.
+	mode := p9Flags & p9.OpenFlagsModeMask
+	if mode == p9.ReadOnly {
+		return nil, nil, p9.QID{}, 0, unix.EINVAL
+	}
+	osFlags |= unix.O_RDWR
+
+	child, err := fd.OpenAt(l.file, ".", osFlags, uint32(perm.Permissions()))
+	if err != nil {
+		return nil, nil, p9.QID{}, 0, extractErrno(err)
+	}
+	stat, err := setOwnerIfNeeded(child.FD(), uid, gid)
+	if err != nil {
+		_ = child.Close()
+		return nil, nil, p9.QID{}, 0, extractErrno(err)
+	}
+
+	c := &localFile{
+		attachPoint: l.attachPoint,
+		hostPath:    join(l.hostPath, fmt.Sprintf("#%d", stat.Ino)),
+		file:        child,
+		mode:        mode,
+		fileType:    unix.S_IFREG,
+		qid:         l.attachPoint.makeQID(&stat),
+	}
+	return newFDMaybe(c.file), c, c.qid, 0, nil
+}
+
 // Mkdir implements p9.File.
 func (l *localFile) Mkdir(name string, perm p9.FileMode, uid p9.UID, gid p9.GID) (p9.QID, error) {
 	if err := l.checkROMount(); err != nil {
@@ -559,6 +645,10 @@ func (l *localFile) walk(names []string) ([]p9.QID, p9.File, unix.Stat_t, error)
 			qid:             l.attachPoint.makeQID(&stat),
 			controlReadable: readable,
 		}
+		if err := c.startWatching(&stat); err != nil {
+			_ = newFile.Close()
+			return nil, nil, unix.Stat_t{}, extractErrno(err)
+		}
 		return []p9.QID{c.qid}, c, stat, nil
 	}
 
@@ -823,6 +913,99 @@ func (l *localFile) Allocate(mode p9.AllocateMode, offset, length uint64) error
 	return nil
 }
 
+// lockTypes maps p9.LockTypes to the lock types of fcntl(2).
+var lockTypes = map[p9.LockType]int16{
+	p9.LockTypeReadLock:  unix.F_RDLCK,
+	p9.LockTypeWriteLock: unix.F_WRLCK,
+	p9.LockTypeUnlock:    unix.F_UNLCK,
+}
+
+// Lock implements p9.Locker.Lock.
+//
+// Locks are open file description locks on the host file, so they are
+// coherent with the locks taken by other clients and by host processes
+// using open file description locks. Host processes using process-associated
+// locks only see them since Linux 3.15.
+func (l *localFile) Lock(typ p9.LockType, start, length uint64) (p9.LockStatus, error) {
+	if !l.isOpen() {
+		return p9.LockStatusError, unix.EBADF
+	}
+	lt, ok := lockTypes[typ]
+	if !ok {
+		return p9.LockStatusError, unix.EINVAL
+	}
+	flock := unix.Flock_t{
+		Type:   lt,
+		Whence: io.SeekStart,
+		Start:  int64(start),
+		Len:    int64(length),
+	}
+	if err := unix.FcntlFlock(uintptr(l.file.FD()), unix.F_OFD_SETLK, &flock); err != nil {
+		if err == unix.EAGAIN || err == unix.EACCES {
+			return p9.LockStatusBlocked, nil
+		}
+		return p9.LockStatusError, extractErrno(err)
+	}
+	return p9.LockStatusOK, nil
+}
+
+// GetLock implements p9.Locker.GetLock.
+func (l *localFile) GetLock(typ p9.LockType, start, length uint64) (p9.LockType, uint64, uint64, error) {
+	if !l.isOpen() {
+		return p9.LockTypeUnlock, 0, 0, unix.EBADF
+	}
+	lt, ok := lockTypes[typ]
+	if !ok || typ == p9.LockTypeUnlock {
+		return p9.LockTypeUnlock, 0, 0, unix.EINVAL
+	}
+	flock := unix.Flock_t{
+		Type:   lt,
+		Whence: io.SeekStart,
+		Start:  int64(start),
+		Len:    int64(length),
+	}
+	if err := unix.FcntlFlock(uintptr(l.file.FD()), unix.F_OFD_GETLK, &flock); err != nil {
+		return p9.LockTypeUnlock, 0, 0, extractErrno(err)
+	}
+	switch flock.Type {
+	case unix.F_RDLCK:
+		return p9.LockTypeReadLock, uint64(flock.Start), uint64(flock.Len), nil
+	case unix.F_WRLCK:
+		return p9.LockTypeWriteLock, uint64(flock.Start), uint64(flock.Len), nil
+	default:
+		return p9.LockTypeUnlock, 0, 0, nil
+	}
+}
+
+// flockOps maps p9.LockTypes to the operations of flock(2).
+var flockOps = map[p9.LockType]int{
+	p9.LockTypeReadLock:  unix.LOCK_SH,
+	p9.LockTypeWriteLock: unix.LOCK_EX,
+	p9.LockTypeUnlock:    unix.LOCK_UN,
+}
+
+// Flock implements p9.Locker.Flock.
+//
+// Locks are taken with flock(2) on the host file, which is opened for each
+// fid, so that they are coherent with the locks taken by other clients and
+// by host processes using flock(2).
+func (l *localFile) Flock(typ p9.LockType) (p9.LockStatus, error) {
+	if !l.isOpen() {
+		return p9.LockStatusError, unix.EBADF
+	}
+	op, ok := flockOps[typ]
+	if !ok {
+		return p9.LockStatusError, unix.EINVAL
+	}
+	if err := unix.Flock(l.file.FD(), op|unix.LOCK_NB); err != nil {
+		if err == unix.EWOULDBLOCK {
+			return p9.LockStatusBlocked, nil
+		}
+		return p9.LockStatusError, extractErrno(err)
+	}
+	return p9.LockStatusOK, nil
+}
+
 // Rename implements p9.File; this should never be called.
 func (*localFile) Rename(p9.File, string) error {
 	panic("rename called directly")
@@ -841,6 +1024,19 @@ func (l *localFile) RenameAt(oldName string, directory p9.File, newName string)
 	return nil
 }
 
+// RenameAtFlags implements p9.FlagRenamer.
+func (l *localFile) RenameAtFlags(oldName string, directory p9.File, newName string, flags uint32) error {
+	if err := l.checkROMount(); err != nil {
+		return err
+	}
+
+	newParent := directory.(*localFile)
+	if err := unix.Renameat2(l.file.FD(), oldName, newParent.file.FD(), newName, uint(flags)); err != nil {
+		return extractErrno(err)
+	}
+	return nil
+}
+
 // ReadAt implements p9.File.
 func (l *localFile) ReadAt(p []byte, offset uint64) (int, error) {
 	if l.mode != p9.ReadOnly && l.mode != p9.ReadWrite {
@@ -1138,8 +1334,84 @@ func (l *localFile) Connect(flags p9.ConnectFlags) (*fd.FD, error) {
 	return fd.New(f), nil
 }
 
+// hostUDSBacklog is the backlog of host sockets created by Bind.
+const hostUDSBacklog = 128
+
+// Bind implements p9.Binder.
+func (l *localFile) Bind(sockType uint32, name string, perm p9.FileMode, uid p9.UID, gid p9.GID) (*fd.FD, p9.QID, error) {
+	// As in Mknod, EPERM lets the client fall back to a socket that is only
+	// reachable from inside the sandbox.
+	if !l.attachPoint.conf.HostUDS || !l.attachPoint.conf.HostUDSCreate {
+		return nil, p9.QID{}, unix.EPERM
+	}
+	if err := l.checkROMount(); err != nil {
+		return nil, p9.QID{}, err
+	}
+
+	// See Connect.
+	const UNIX_PATH_MAX = 108 // defined in afunix.h
+	hostPath := join(l.hostPath, name)
+	if len(hostPath) > UNIX_PATH_MAX {
+		return nil, p9.QID{}, unix.EPERM
+	}
+
+	switch sockType {
+	case unix.SOCK_STREAM, unix.SOCK_SEQPACKET:
+	default:
+		return nil, p9.QID{}, unix.EPERM
+	}
+
+	f, err := unix.Socket(unix.AF_UNIX, int(sockType), 0)
+	if err != nil {
+		return nil, p9.QID{}, err
+	}
+	sock := fd.New(f)
+	cu := cleanup.Make(func() {
+		_ = sock.Close()
+	})
+	defer cu.Clean()
+
+	if err := unix.SetNonblock(f, true); err != nil {
+		return nil, p9.QID{}, err
+	}
+	if err := unix.Bind(f, &unix.SockaddrUnix{Name: hostPath}); err != nil {
+		return nil, p9.QID{}, err
+	}
+	cu.Add(func() {
+		// Best effort attempt to remove the socket file in case of failure.
+		if err := unix.Unlinkat(l.file.FD(), name, 0); err != nil {
+			log.Warningf("error unlinking socket %q after failure: %v", hostPath, err)
+		}
+	})
+	if err := unix.Fchmodat(l.file.FD(), name, uint32(perm.Permissions()), 0); err != nil {
+		return nil, p9.QID{}, err
+	}
+
+	// Open the socket file to change ownership and stat it.
+	child, err := fd.OpenAt(l.file, name, unix.O_PATH|openFlags, 0)
+	if err != nil {
+		return nil, p9.QID{}, extractErrno(err)
+	}
+	defer child.Close()
+	stat, err := setOwnerIfNeeded(child.FD(), uid, gid)
+	if err != nil {
+		return nil, p9.QID{}, extractErrno(err)
+	}
+
+	if err := unix.Listen(f, hostUDSBacklog); err != nil {
+		return nil, p9.QID{}, err
+	}
+
+	cu.Release()
+	return sock, l.attachPoint.makeQID(&stat), nil
+}
+
 // Close implements p9.File.
 func (l *localFile) Close() error {
+	if l.wd != 0 {
+		l.attachPoint.watcher.remove(l.wd)
+		l.wd = 0
+	}
 	l.mode = invalidMode
 	err := l.file.Close()
 	l.file = nil
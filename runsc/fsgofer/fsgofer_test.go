@@ -22,6 +22,7 @@ import (
 	"path"
 	"path/filepath"
 	"testing"
+	"time"
 
 	"github.com/syndtr/gocapability/capability"
 	"golang.org/x/sys/unix"
@@ -284,6 +285,76 @@ func TestCreate(t *testing.T) {
 	})
 }
 
+func TestCreateTmpfile(t *testing.T) {
+	if !specutils.HasCapabilities(capability.CAP_DAC_READ_SEARCH) {
+		t.Skipf("Test requires CAP_DAC_READ_SEARCH")
+	}
+
+	runCustom(t, []uint32{unix.S_IFDIR}, rwConfs, func(t *testing.T, s state) {
+		_, l, _, _, err := s.file.CreateTmpfile(p9.ReadWrite, 0644, p9.UID(os.Getuid()), p9.GID(os.Getgid()))
+		if err != nil {
+			t.Fatalf("%v: CreateTmpfile() failed: %v", s, err)
+		}
+		if err := testReadWrite(l, p9.ReadWrite, nil); err != nil {
+			t.Fatalf("%v: testReadWrite() failed: %v", s, err)
+		}
+		if err := s.file.Link(l, "linked"); err != nil {
+			t.Fatalf("%v: Link() failed: %v", s, err)
+		}
+		if _, err := os.Stat(path.Join(s.file.hostPath, "linked")); err != nil {
+			t.Errorf("%v: linked file not found: %v", s, err)
+		}
+
+		_, l, _, _, err = s.file.CreateTmpfile(p9.ReadWrite|p9.OpenExclusive, 0644, p9.UID(os.Getuid()), p9.GID(os.Getgid()))
+		if err != nil {
+			t.Fatalf("%v: CreateTmpfile(OpenExclusive) failed: %v", s, err)
+		}
+		if err := s.file.Link(l, "excl"); err != unix.ENOENT {
+			t.Errorf("%v: Link() of exclusive file got error %v, want %v", s, err, unix.ENOENT)
+		}
+	})
+}
+
+func TestBind(t *testing.T) {
+	runCustom(t, []uint32{unix.S_IFDIR}, rwConfs, func(t *testing.T, s state) {
+		if _, _, err := s.file.Bind(unix.SOCK_STREAM, "sock", 0755, p9.UID(os.Getuid()), p9.GID(os.Getgid())); err != unix.EPERM {
+			t.Errorf("%v: Bind() without HostUDSCreate got error %v, want %v", s, err, unix.EPERM)
+		}
+	})
+
+	confs := []Config{{HostUDS: true, HostUDSCreate: true}}
+	runCustom(t, []uint32{unix.S_IFDIR}, confs, func(t *testing.T, s state) {
+		sock, _, err := s.file.Bind(unix.SOCK_STREAM, "sock", 0755, p9.UID(os.Getuid()), p9.GID(os.Getgid()))
+		if err != nil {
+			t.Fatalf("%v: Bind() failed: %v", s, err)
+		}
+		defer sock.Close()
+
+		name := path.Join(s.file.hostPath, "sock")
+		var stat unix.Stat_t
+		if err := unix.Stat(name, &stat); err != nil {
+			t.Fatalf("%v: Stat() failed: %v", s, err)
+		}
+		if got, want := stat.Mode, uint32(unix.S_IFSOCK|0755); got != want {
+			t.Errorf("%v: socket file has mode %#o, want %#o", s, got, want)
+		}
+
+		client, err := unix.Socket(unix.AF_UNIX, unix.SOCK_STREAM, 0)
+		if err != nil {
+			t.Fatalf("Socket() failed: %v", err)
+		}
+		defer unix.Close(client)
+		if err := unix.Connect(client, &unix.SockaddrUnix{Name: name}); err != nil {
+			t.Fatalf("%v: Connect() failed: %v", s, err)
+		}
+		nfd, _, err := unix.Accept(sock.FD())
+		if err != nil {
+			t.Fatalf("%v: Accept() failed: %v", s, err)
+		}
+		unix.Close(nfd)
+	})
+}
+
 func checkIDs(f p9.File, uid, gid int) error {
 	_, _, stat, err := f.GetAttr(p9.AttrMask{UID: true, GID: true})
 	if err != nil {
@@ -957,6 +1028,142 @@ func TestMknod(t *testing.T) {
 	})
 }
 
+func TestLock(t *testing.T) {
+	runCustom(t, []uint32{unix.S_IFDIR}, rwConfs, func(t *testing.T, s state) {
+		first, err := createFile(s.file, "test")
+		if err != nil {
+			t.Fatalf("createFile() failed: %v", err)
+		}
+		defer first.Close()
+		_, f, err := s.file.Walk([]string{"test"})
+		if err != nil {
+			t.Fatalf("Walk() failed: %v", err)
+		}
+		defer f.Close()
+		if _, _, _, err := f.Open(p9.ReadWrite); err != nil {
+			t.Fatalf("Open() failed: %v", err)
+		}
+		second := f.(*localFile)
+
+		if status, err := first.Lock(p9.LockTypeWriteLock, 0, 10); status != p9.LockStatusOK || err != nil {
+			t.Fatalf("Lock() got (%v, %v), want (%v, nil)", status, err, p9.LockStatusOK)
+		}
+		// Locks held through distinct files conflict.
+		if status, err := second.Lock(p9.LockTypeReadLock, 5, 0); status != p9.LockStatusBlocked || err != nil {
+			t.Fatalf("Lock() got (%v, %v), want (%v, nil)", status, err, p9.LockStatusBlocked)
+		}
+		typ, start, length, err := second.GetLock(p9.LockTypeReadLock, 5, 0)
+		if err != nil {
+			t.Fatalf("GetLock() failed: %v", err)
+		}
+		if typ != p9.LockTypeWriteLock || start != 0 || length != 10 {
+			t.Errorf("GetLock() got (%v, %d, %d), want (%v, 0, 10)", typ, start, length, p9.LockTypeWriteLock)
+		}
+
+		if status, err := first.Lock(p9.LockTypeUnlock, 0, 0); status != p9.LockStatusOK || err != nil {
+			t.Fatalf("Lock(Unlock) got (%v, %v), want (%v, nil)", status, err, p9.LockStatusOK)
+		}
+		if status, err := second.Lock(p9.LockTypeReadLock, 5, 0); status != p9.LockStatusOK || err != nil {
+			t.Fatalf("Lock() after unlock got (%v, %v), want (%v, nil)", status, err, p9.LockStatusOK)
+		}
+	})
+}
+
+func TestFlock(t *testing.T) {
+	runCustom(t, []uint32{unix.S_IFDIR}, rwConfs, func(t *testing.T, s state) {
+		first, err := createFile(s.file, "test")
+		if err != nil {
+			t.Fatalf("createFile() failed: %v", err)
+		}
+		defer first.Close()
+		_, f, err := s.file.Walk([]string{"test"})
+		if err != nil {
+			t.Fatalf("Walk() failed: %v", err)
+		}
+		defer f.Close()
+		if _, _, _, err := f.Open(p9.ReadOnly); err != nil {
+			t.Fatalf("Open() failed: %v", err)
+		}
+		second := f.(*localFile)
+
+		if status, err := first.Flock(p9.LockTypeReadLock); status != p9.LockStatusOK || err != nil {
+			t.Fatalf("Flock() got (%v, %v), want (%v, nil)", status, err, p9.LockStatusOK)
+		}
+		// Shared locks held through distinct files don't conflict...
+		if status, err := second.Flock(p9.LockTypeReadLock); status != p9.LockStatusOK || err != nil {
+			t.Fatalf("Flock() got (%v, %v), want (%v, nil)", status, err, p9.LockStatusOK)
+		}
+		// ... but exclusive ones do.
+		if status, err := first.Flock(p9.LockTypeWriteLock); status != p9.LockStatusBlocked || err != nil {
+			t.Fatalf("Flock() got (%v, %v), want (%v, nil)", status, err, p9.LockStatusBlocked)
+		}
+		// BSD locks don't conflict with record locks.
+		if status, err := first.Lock(p9.LockTypeWriteLock, 0, 0); status != p9.LockStatusOK || err != nil {
+			t.Fatalf("Lock() got (%v, %v), want (%v, nil)", status, err, p9.LockStatusOK)
+		}
+
+		if status, err := second.Flock(p9.LockTypeUnlock); status != p9.LockStatusOK || err != nil {
+			t.Fatalf("Flock(Unlock) got (%v, %v), want (%v, nil)", status, err, p9.LockStatusOK)
+		}
+		if status, err := first.Flock(p9.LockTypeWriteLock); status != p9.LockStatusOK || err != nil {
+			t.Fatalf("Flock() after unlock got (%v, %v), want (%v, nil)", status, err, p9.LockStatusOK)
+		}
+	})
+}
+
+func TestInvalidations(t *testing.T) {
+	path, err := ioutil.TempDir(testutil.TmpDir(), "root-")
+	if err != nil {
+		t.Fatalf("ioutil.TempDir() failed: %v", err)
+	}
+	defer os.RemoveAll(path)
+
+	a, err := NewAttachPoint(path, Config{Invalidations: true})
+	if err != nil {
+		t.Fatalf("NewAttachPoint failed: %v", err)
+	}
+	inv, ok := a.(p9.Invalidator)
+	if !ok {
+		t.Fatalf("attach point %T doesn't implement p9.Invalidator", a)
+	}
+	root, err := a.Attach()
+	if err != nil {
+		t.Fatalf("Attach failed, err: %v", err)
+	}
+	defer root.Close()
+	qid, _, _, err := root.GetAttr(p9.AttrMask{})
+	if err != nil {
+		t.Fatalf("GetAttr() failed: %v", err)
+	}
+
+	// The first request tells the client where to start.
+	_, seq, overflow, err := inv.WaitInvalidations(0, 10, 0)
+	if err != nil {
+		t.Fatalf("WaitInvalidations(0) failed: %v", err)
+	}
+	if !overflow {
+		t.Fatalf("WaitInvalidations(0) didn't report an overflow")
+	}
+
+	if err := ioutil.WriteFile(filepath.Join(path, "file"), nil, 0644); err != nil {
+		t.Fatalf("WriteFile() failed: %v", err)
+	}
+	invs, next, overflow, err := inv.WaitInvalidations(seq, 10, 10*time.Second)
+	if err != nil {
+		t.Fatalf("WaitInvalidations(%d) failed: %v", seq, err)
+	}
+	if overflow {
+		t.Fatalf("WaitInvalidations(%d) reported an overflow", seq)
+	}
+	want := p9.Invalidation{QIDPath: qid.Path, Name: "file", Mask: unix.IN_CREATE}
+	if len(invs) == 0 || invs[0] != want {
+		t.Fatalf("WaitInvalidations(%d) got %v, want %v first", seq, invs, want)
+	}
+	if got, want := next, seq+uint64(len(invs)); got != want {
+		t.Errorf("WaitInvalidations(%d) got next %d, want %d", seq, got, want)
+	}
+}
+
 func BenchmarkWalkOne(b *testing.B) {
 	path, name, err := setup(unix.S_IFDIR)
 	if err != nil {
@@ -80,3 +80,8 @@ func renameat(oldDirFD int, oldName string, newDirFD int, newName string) error
 	}
 	return nil
 }
+
+// inotifyEvent returns the inotify event at the start of b.
+func inotifyEvent(b []byte) *unix.InotifyEvent {
+	return (*unix.InotifyEvent)(unsafe.Pointer(&b[0]))
+}
@@ -0,0 +1,309 @@
+// Copyright 2021 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fsgofer
+
+import (
+	"bytes"
+	"fmt"
+	"time"
+
+	"golang.org/x/sys/unix"
+	"gvisor.dev/gvisor/pkg/fd"
+	"gvisor.dev/gvisor/pkg/log"
+	"gvisor.dev/gvisor/pkg/p9"
+	"gvisor.dev/gvisor/pkg/sync"
+)
+
+const (
+	// watchMask is the mask of the inotify events reported as invalidations.
+	// Events on the entries of watched directories are reported with the
+	// entry name, which covers changes to the metadata of all files the
+	// client can reach. The event mask is reported as well, so that clients
+	// can deliver the events to their own inotify watches.
+	watchMask = unix.IN_ATTRIB | unix.IN_CLOSE_WRITE | unix.IN_CREATE | unix.IN_DELETE | unix.IN_DELETE_SELF | unix.IN_MODIFY | unix.IN_MOVE_SELF | unix.IN_MOVED_FROM | unix.IN_MOVED_TO | unix.IN_DONT_FOLLOW | unix.IN_EXCL_UNLINK
+
+	// maxRetainedInvalidations is the maximum number of invalidations
+	// retained for clients. Clients that fall further behind are reported
+	// an overflow.
+	maxRetainedInvalidations = 4096
+)
+
+// watch is an inotify watch shared by the localFiles of a file.
+type watch struct {
+	qidPath uint64
+	refs    int
+}
+
+// invalidationWatcher reports the changes made to the files of an attach
+// point with inotify.
+//
+// Changes made to a file through a hard link in a directory that isn't
+// watched aren't reported.
+type invalidationWatcher struct {
+	// inotifyFD is the inotify instance. It is immutable.
+	inotifyFD int
+
+	// rootFD is the root directory, which is restored as the working
+	// directory after adding watches to directories. It is immutable.
+	rootFD *fd.FD
+
+	// addMu serializes adding watches, which changes the working
+	// directory.
+	addMu sync.Mutex
+
+	// watchesMu protects watches.
+	watchesMu sync.Mutex
+
+	// watches maps watch descriptors to their watch.
+	watches map[int32]*watch
+
+	// mu protects the fields below.
+	mu sync.Mutex
+
+	// invalidations are the retained invalidations. invalidations[i] has
+	// sequence number first+i.
+	invalidations []p9.Invalidation
+	first         uint64
+
+	// changed is closed and replaced when invalidations are added.
+	changed chan struct{}
+
+	// err is set once a file can't be watched. Changes aren't reported
+	// anymore then, as the client can't tell which files they miss.
+	err error
+}
+
+func newInvalidationWatcher() (*invalidationWatcher, error) {
+	inotifyFD, err := unix.InotifyInit1(unix.IN_CLOEXEC)
+	if err != nil {
+		return nil, fmt.Errorf("inotify_init1(): %v", err)
+	}
+	rootFD, err := fd.Open("/", unix.O_PATH|unix.O_DIRECTORY|unix.O_CLOEXEC, 0)
+	if err != nil {
+		_ = unix.Close(inotifyFD)
+		return nil, fmt.Errorf("opening /: %v", err)
+	}
+	w := &invalidationWatcher{
+		inotifyFD: inotifyFD,
+		rootFD:    rootFD,
+		watches:   make(map[int32]*watch),
+		// Sequence number 0 is always behind, so that new clients are
+		// reported an overflow telling them where to start.
+		first:   1,
+		changed: make(chan struct{}),
+	}
+	go w.run() // S/R-SAFE: the gofer isn't saved.
+	return w, nil
+}
+
+// add starts reporting changes to l, and to the entries of l if it is a
+// directory. It returns the watch descriptor, or 0 if l isn't watched.
+//
+// Changes made before add returns may not be reported, so the attributes of
+// l must be read afterwards.
+func (w *invalidationWatcher) add(l *localFile) int32 {
+	// Register the watch before events for it are read.
+	w.watchesMu.Lock()
+	defer w.watchesMu.Unlock()
+
+	var (
+		wd  int
+		err error
+	)
+	switch {
+	case l.fileType == unix.S_IFDIR:
+		// inotify_add_watch(2) takes a path, which may be renamed
+		// concurrently, so watch the working directory instead.
+		w.addMu.Lock()
+		if err = unix.Fchdir(l.file.FD()); err == nil {
+			wd, err = unix.InotifyAddWatch(w.inotifyFD, ".", watchMask)
+			if err := unix.Fchdir(w.rootFD.FD()); err != nil {
+				panic(fmt.Sprintf("fchdir(/): %v", err))
+			}
+		}
+		w.addMu.Unlock()
+	case l.hostPath == l.attachPoint.prefix:
+		// Files that aren't directories are watched through their
+		// parent directory, except the root of the attach point.
+		wd, err = unix.InotifyAddWatch(w.inotifyFD, l.hostPath, watchMask)
+	default:
+		return 0
+	}
+	if err != nil {
+		log.Warningf("Unable to watch %q, no longer reporting invalidations: %v", l.hostPath, err)
+		w.mu.Lock()
+		if w.err == nil {
+			w.err = err
+			close(w.changed)
+			w.changed = make(chan struct{})
+		}
+		w.mu.Unlock()
+		return 0
+	}
+
+	// Watching the same file again returns the same watch descriptor.
+	if wt, ok := w.watches[int32(wd)]; ok {
+		wt.refs++
+	} else {
+		w.watches[int32(wd)] = &watch{qidPath: l.qid.Path, refs: 1}
+	}
+	return int32(wd)
+}
+
+// remove drops a reference on the watch descriptor wd returned by add.
+func (w *invalidationWatcher) remove(wd int32) {
+	w.watchesMu.Lock()
+	defer w.watchesMu.Unlock()
+	wt, ok := w.watches[wd]
+	if !ok {
+		// The file was deleted, which removed the watch.
+		return
+	}
+	wt.refs--
+	if wt.refs == 0 {
+		delete(w.watches, wd)
+		_, _ = unix.InotifyRmWatch(w.inotifyFD, uint32(wd))
+	}
+}
+
+// run reads inotify events and turns them into invalidations.
+func (w *invalidationWatcher) run() {
+	buf := make([]byte, 64*1024)
+	for {
+		n, err := unix.Read(w.inotifyFD, buf)
+		if err != nil {
+			if err == unix.EINTR {
+				continue
+			}
+			panic(fmt.Sprintf("reading inotify events: %v", err))
+		}
+
+		var invs []p9.Invalidation
+		overflow := false
+		w.watchesMu.Lock()
+		for b := buf[:n]; len(b) >= unix.SizeofInotifyEvent; {
+			ev := inotifyEvent(b)
+			name := b[unix.SizeofInotifyEvent : unix.SizeofInotifyEvent+ev.Len]
+			b = b[unix.SizeofInotifyEvent+ev.Len:]
+
+			if ev.Mask&unix.IN_Q_OVERFLOW != 0 {
+				overflow = true
+				continue
+			}
+			wt, ok := w.watches[ev.Wd]
+			if !ok {
+				continue
+			}
+			if ev.Mask&unix.IN_IGNORED != 0 {
+				// The watch was removed, either explicitly or because
+				// the file was deleted.
+				delete(w.watches, ev.Wd)
+				continue
+			}
+			// The name is padded with NULs.
+			if i := bytes.IndexByte(name, 0); i >= 0 {
+				name = name[:i]
+			}
+			invs = append(invs, p9.Invalidation{
+				QIDPath: wt.qidPath,
+				Name:    string(name),
+				Mask:    ev.Mask,
+				Cookie:  ev.Cookie,
+			})
+		}
+		w.watchesMu.Unlock()
+
+		w.report(invs, overflow)
+	}
+}
+
+// report adds invalidations and wakes up waiters.
+func (w *invalidationWatcher) report(invs []p9.Invalidation, overflow bool) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	next := w.first + uint64(len(w.invalidations))
+	if overflow {
+		// Skip a sequence number, so that clients that were up to date
+		// are behind.
+		w.invalidations = nil
+		w.first = next + 1
+	}
+	for _, inv := range invs {
+		// Changes are commonly reported several times in a row, e.g.
+		// for each write to a file.
+		if l := len(w.invalidations); l > 0 && w.invalidations[l-1] == inv {
+			continue
+		}
+		w.invalidations = append(w.invalidations, inv)
+	}
+	if drop := len(w.invalidations) - maxRetainedInvalidations; drop > 0 {
+		w.invalidations = append([]p9.Invalidation(nil), w.invalidations[drop:]...)
+		w.first += uint64(drop)
+	}
+	if w.first+uint64(len(w.invalidations)) != next || overflow {
+		close(w.changed)
+		w.changed = make(chan struct{})
+	}
+}
+
+// wait implements p9.Invalidator.WaitInvalidations.
+func (w *invalidationWatcher) wait(seq uint64, max int, timeout time.Duration) ([]p9.Invalidation, uint64, bool, error) {
+	var timer *time.Timer
+	for {
+		w.mu.Lock()
+		if w.err != nil {
+			err := w.err
+			w.mu.Unlock()
+			return nil, 0, false, err
+		}
+		next := w.first + uint64(len(w.invalidations))
+		if seq < w.first || seq > next {
+			w.mu.Unlock()
+			return nil, next, true, nil
+		}
+		if seq < next {
+			invs := w.invalidations[seq-w.first:]
+			if len(invs) > max {
+				invs = invs[:max]
+			}
+			invs = append([]p9.Invalidation(nil), invs...)
+			w.mu.Unlock()
+			return invs, seq + uint64(len(invs)), false, nil
+		}
+		changed := w.changed
+		w.mu.Unlock()
+
+		if timer == nil {
+			timer = time.NewTimer(timeout)
+			defer timer.Stop()
+		}
+		select {
+		case <-changed:
+		case <-timer.C:
+			return nil, seq, false, nil
+		}
+	}
+}
+
+// invalidatingAttachPoint is an attachPoint reporting the changes made to its
+// files.
+type invalidatingAttachPoint struct {
+	*attachPoint
+}
+
+// WaitInvalidations implements p9.Invalidator.WaitInvalidations.
+func (a invalidatingAttachPoint) WaitInvalidations(seq uint64, max int, timeout time.Duration) ([]p9.Invalidation, uint64, bool, error) {
+	return a.watcher.wait(seq, max, timeout)
+}
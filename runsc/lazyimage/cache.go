@@ -0,0 +1,236 @@
+// Copyright 2021 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package lazyimage
+
+import (
+	"container/list"
+	"crypto/rand"
+	"fmt"
+	"os"
+	"strings"
+
+	"golang.org/x/sys/unix"
+	"gvisor.dev/gvisor/pkg/log"
+	"gvisor.dev/gvisor/pkg/sync"
+)
+
+// memoryCacheSize is the maximum number of bytes of chunks kept in memory.
+// The sentry caches the file data that it reads, so this mostly serves reads
+// of the same chunk in quick succession, e.g. when a file is read page by
+// page.
+const memoryCacheSize = 64 << 20
+
+// chunkKey identifies a chunk.
+type chunkKey struct {
+	// blob is the digest of the blob that holds the chunk.
+	blob string
+
+	// offset is the offset of the chunk's gzip member in the blob.
+	offset int64
+
+	// innerOffset is the offset of the chunk in its decompressed gzip
+	// member, which may hold several small chunks.
+	innerOffset int64
+}
+
+// fileName returns the name of the file that caches the chunk on disk.
+func (k chunkKey) fileName() string {
+	return fmt.Sprintf("%s-%d-%d", strings.Replace(k.blob, ":", "-", 1), k.offset, k.innerOffset)
+}
+
+// chunkCache caches decompressed chunks, in memory and, optionally, in a
+// directory on disk that persists across sandboxes.
+type chunkCache struct {
+	// dirFD is an FD for the directory of the disk cache, or -1 if there is
+	// none. The directory is accessed by FD because the gofer doesn't have
+	// access to its path after it changes its root.
+	dirFD int
+
+	// mu protects the fields below.
+	mu sync.Mutex
+
+	// lru holds the chunks in memory, most recently used first, as
+	// *cacheEntry.
+	lru list.List
+
+	// entries maps chunks in memory to their element in lru.
+	entries map[chunkKey]*list.Element
+
+	// size is the total size of chunks in memory.
+	size int
+
+	// fetches are the chunks being fetched.
+	fetches map[chunkKey]*fetch
+}
+
+// cacheEntry is a chunk in memory.
+type cacheEntry struct {
+	key  chunkKey
+	data []byte
+}
+
+// fetch is a chunk being fetched.
+type fetch struct {
+	done chan struct{}
+	data []byte
+	err  error
+}
+
+// newChunkCache returns a cache that stores chunks in the directory at dir,
+// which is created if needed, or only in memory if dir is empty.
+func newChunkCache(dir string) (*chunkCache, error) {
+	c := &chunkCache{
+		dirFD:   -1,
+		entries: make(map[chunkKey]*list.Element),
+		fetches: make(map[chunkKey]*fetch),
+	}
+	if dir == "" {
+		return c, nil
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, fmt.Errorf("creating cache directory %q: %v", dir, err)
+	}
+	fd, err := unix.Open(dir, unix.O_RDONLY|unix.O_DIRECTORY|unix.O_CLOEXEC, 0)
+	if err != nil {
+		return nil, fmt.Errorf("opening cache directory %q: %v", dir, err)
+	}
+	c.dirFD = fd
+	return c, nil
+}
+
+// get returns the chunk with the given key, calling fill to fetch it if it
+// isn't cached. Concurrent gets of the same chunk fetch it once. verify is
+// called on chunks read from the disk cache.
+func (c *chunkCache) get(key chunkKey, fill func() ([]byte, error), verify func([]byte) error) ([]byte, error) {
+	c.mu.Lock()
+	if e, ok := c.entries[key]; ok {
+		c.lru.MoveToFront(e)
+		c.mu.Unlock()
+		return e.Value.(*cacheEntry).data, nil
+	}
+	if f, ok := c.fetches[key]; ok {
+		c.mu.Unlock()
+		<-f.done
+		return f.data, f.err
+	}
+	f := &fetch{done: make(chan struct{})}
+	c.fetches[key] = f
+	c.mu.Unlock()
+
+	if f.data = c.readDisk(key, verify); f.data == nil {
+		if f.data, f.err = fill(); f.err == nil {
+			c.writeDisk(key, f.data)
+		}
+	}
+
+	c.mu.Lock()
+	delete(c.fetches, key)
+	if f.err == nil {
+		c.insertLocked(key, f.data)
+	}
+	c.mu.Unlock()
+	close(f.done)
+	return f.data, f.err
+}
+
+// insertLocked adds a chunk to the memory cache, evicting the least recently
+// used chunks as needed.
+//
+// Preconditions: c.mu must be locked.
+func (c *chunkCache) insertLocked(key chunkKey, data []byte) {
+	if len(data) > memoryCacheSize {
+		return
+	}
+	c.entries[key] = c.lru.PushFront(&cacheEntry{key: key, data: data})
+	c.size += len(data)
+	for c.size > memoryCacheSize {
+		e := c.lru.Back()
+		ce := e.Value.(*cacheEntry)
+		c.lru.Remove(e)
+		delete(c.entries, ce.key)
+		c.size -= len(ce.data)
+	}
+}
+
+// readDisk returns the chunk with the given key from the disk cache, or nil
+// if it isn't there. Chunks that fail verification are removed.
+func (c *chunkCache) readDisk(key chunkKey, verify func([]byte) error) []byte {
+	if c.dirFD < 0 {
+		return nil
+	}
+	name := key.fileName()
+	fd, err := unix.Openat(c.dirFD, name, unix.O_RDONLY|unix.O_CLOEXEC, 0)
+	if err != nil {
+		return nil
+	}
+	defer unix.Close(fd)
+	var stat unix.Stat_t
+	if err := unix.Fstat(fd, &stat); err != nil {
+		return nil
+	}
+	data := make([]byte, stat.Size)
+	for done := 0; done < len(data); {
+		n, err := unix.Pread(fd, data[done:], int64(done))
+		if err != nil || n == 0 {
+			log.Warningf("Reading cached chunk %q failed: %v", name, err)
+			return nil
+		}
+		done += n
+	}
+	if err := verify(data); err != nil {
+		log.Warningf("Removing corrupted cached chunk %q: %v", name, err)
+		unix.Unlinkat(c.dirFD, name, 0)
+		return nil
+	}
+	return data
+}
+
+// writeDisk stores a chunk in the disk cache. Failures are only logged, since
+// the chunk can be fetched again.
+func (c *chunkCache) writeDisk(key chunkKey, data []byte) {
+	if c.dirFD < 0 {
+		return
+	}
+	name := key.fileName()
+	// Gofers of other sandboxes may be writing the same chunk.
+	var suffix [8]byte
+	if _, err := rand.Read(suffix[:]); err != nil {
+		log.Warningf("Caching chunk %q failed: %v", name, err)
+		return
+	}
+	tmp := fmt.Sprintf(".%s.%x.tmp", name, suffix)
+	fd, err := unix.Openat(c.dirFD, tmp, unix.O_WRONLY|unix.O_CREAT|unix.O_TRUNC|unix.O_CLOEXEC, 0600)
+	if err != nil {
+		log.Warningf("Caching chunk %q failed: %v", name, err)
+		return
+	}
+	for done := 0; done < len(data); {
+		n, err := unix.Pwrite(fd, data[done:], int64(done))
+		if err != nil {
+			log.Warningf("Caching chunk %q failed: %v", name, err)
+			unix.Close(fd)
+			unix.Unlinkat(c.dirFD, tmp, 0)
+			return
+		}
+		done += n
+	}
+	unix.Close(fd)
+	// Renaming makes the chunk visible to other sandboxes only once it is
+	// complete.
+	if err := unix.Renameat(c.dirFD, tmp, c.dirFD, name); err != nil {
+		log.Warningf("Caching chunk %q failed: %v", name, err)
+		unix.Unlinkat(c.dirFD, tmp, 0)
+	}
+}
@@ -0,0 +1,234 @@
+// Copyright 2021 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package lazyimage
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+
+	"gvisor.dev/gvisor/pkg/log"
+	"gvisor.dev/gvisor/pkg/sync"
+)
+
+// The gofer has no network access, so requests to registries are sent by a
+// separate fetcher process on its behalf. The gofer and the fetcher are
+// connected by a fixed set of connections, created before either starts, over
+// which the gofer sends HTTP/1.1 requests in proxy form and the fetcher
+// replies with the responses that it gets. Each connection carries one
+// request at a time.
+
+// FetcherConns is the number of connections between the gofer and the
+// fetcher, which bounds the number of concurrent requests to registries.
+const FetcherConns = 8
+
+// fetcherErrorHeader is set on responses that the fetcher makes up when it
+// fails to send a request. Its value is the error.
+const fetcherErrorHeader = "Gvisor-Fetcher-Error"
+
+// fetcherConn is a connection to the fetcher.
+type fetcherConn struct {
+	rw io.ReadWriteCloser
+	r  *bufio.Reader
+}
+
+// fetcherTransport is an http.RoundTripper that sends requests through the
+// fetcher.
+type fetcherTransport struct {
+	// idle holds the connections that don't carry a request.
+	idle chan *fetcherConn
+
+	// mu protects live.
+	mu sync.Mutex
+
+	// live is the number of connections that are still usable.
+	live int
+
+	// dead is closed when live drops to 0.
+	dead chan struct{}
+}
+
+// NewFetcherTransport returns a transport that sends requests through a
+// fetcher that serves conns with ServeFetcher. It takes ownership of conns.
+func NewFetcherTransport(conns []io.ReadWriteCloser) http.RoundTripper {
+	t := &fetcherTransport{
+		idle: make(chan *fetcherConn, len(conns)),
+		live: len(conns),
+		dead: make(chan struct{}),
+	}
+	for _, conn := range conns {
+		t.idle <- &fetcherConn{rw: conn, r: bufio.NewReader(conn)}
+	}
+	if len(conns) == 0 {
+		close(t.dead)
+	}
+	return t
+}
+
+// RoundTrip implements http.RoundTripper.RoundTrip.
+func (t *fetcherTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	var c *fetcherConn
+	select {
+	case c = <-t.idle:
+	case <-t.dead:
+		return nil, errors.New("lost all connections to the image fetcher")
+	case <-req.Context().Done():
+		return nil, req.Context().Err()
+	}
+	if err := req.WriteProxy(c.rw); err != nil {
+		t.drop(c)
+		return nil, fmt.Errorf("sending request to the image fetcher: %v", err)
+	}
+	resp, err := http.ReadResponse(c.r, req)
+	if err != nil {
+		t.drop(c)
+		return nil, fmt.Errorf("reading response from the image fetcher: %v", err)
+	}
+	resp.Body = &fetcherBody{ReadCloser: resp.Body, t: t, c: c}
+	if msg := resp.Header.Get(fetcherErrorHeader); msg != "" {
+		resp.Body.Close()
+		return nil, errors.New(msg)
+	}
+	return resp, nil
+}
+
+// drop closes c, which can't be used anymore.
+func (t *fetcherTransport) drop(c *fetcherConn) {
+	c.rw.Close()
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.live--
+	if t.live == 0 {
+		close(t.dead)
+	}
+}
+
+// fetcherBody is the body of a response from the fetcher. Closing it makes its
+// connection available to other requests.
+type fetcherBody struct {
+	io.ReadCloser
+	t *fetcherTransport
+
+	// c is the connection of the response, or nil if the body is closed.
+	c *fetcherConn
+}
+
+// Close implements io.Closer.Close.
+func (b *fetcherBody) Close() error {
+	if b.c == nil {
+		return nil
+	}
+	// The rest of the response must be read before the next one.
+	_, err := io.Copy(ioutil.Discard, b.ReadCloser)
+	b.ReadCloser.Close()
+	if err != nil {
+		b.t.drop(b.c)
+	} else {
+		b.t.idle <- b.c
+	}
+	b.c = nil
+	return nil
+}
+
+// ServeFetcher sends the requests received on conns from a transport returned
+// by NewFetcherTransport with transport, and replies with their responses. It
+// returns once all conns have been closed by the other end.
+func ServeFetcher(conns []io.ReadWriteCloser, transport http.RoundTripper) {
+	var wg sync.WaitGroup
+	for _, conn := range conns {
+		wg.Add(1)
+		go func(conn io.ReadWriteCloser) {
+			defer wg.Done()
+			defer conn.Close()
+			if err := serveFetcherConn(conn, transport); err != nil {
+				log.Warningf("Serving image fetcher connection: %v", err)
+			}
+		}(conn)
+	}
+	wg.Wait()
+}
+
+func serveFetcherConn(conn io.ReadWriter, transport http.RoundTripper) error {
+	r := bufio.NewReader(conn)
+	for {
+		req, err := http.ReadRequest(r)
+		if err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+		resp := fetchForGofer(req, transport)
+		// Responses of unknown length are chunked rather than delimited by
+		// closing the connection, which must stay open for later requests.
+		resp.Proto, resp.ProtoMajor, resp.ProtoMinor = "HTTP/1.1", 1, 1
+		resp.Close = false
+		resp.Uncompressed = false
+		if resp.ContentLength < 0 {
+			resp.TransferEncoding = []string{"chunked"}
+		} else {
+			resp.TransferEncoding = nil
+		}
+		err = resp.Write(conn)
+		resp.Body.Close()
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// fetchForGofer sends req, which was received from the gofer, with transport.
+func fetchForGofer(req *http.Request, transport http.RoundTripper) *http.Response {
+	// The gofer only reads from registries.
+	var err error
+	if req.Method != http.MethodGet && req.Method != http.MethodHead {
+		err = fmt.Errorf("method %s not allowed", req.Method)
+	} else if req.URL.Scheme != "https" && req.URL.Scheme != "http" {
+		err = fmt.Errorf("URL %q not allowed", req.URL)
+	}
+	if err != nil {
+		// Closing the body reads the rest of the request, which must be
+		// done before the next one.
+		req.Body.Close()
+		return fetchError(req, err)
+	}
+	req.RequestURI = ""
+	log.Debugf("Fetching %s %s", req.Method, req.URL)
+	resp, err := transport.RoundTrip(req)
+	if err != nil {
+		return fetchError(req, err)
+	}
+	return resp
+}
+
+// fetchError returns a response that makes the gofer's request fail with err.
+func fetchError(req *http.Request, err error) *http.Response {
+	msg := strings.Map(func(r rune) rune {
+		if r < ' ' || r == 0x7f {
+			return ' '
+		}
+		return r
+	}, err.Error())
+	return &http.Response{
+		StatusCode: http.StatusBadGateway,
+		Header:     http.Header{fetcherErrorHeader: {msg}},
+		Body:       http.NoBody,
+		Request:    req,
+	}
+}
@@ -0,0 +1,311 @@
+// Copyright 2021 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package lazyimage
+
+import (
+	"golang.org/x/sys/unix"
+	"gvisor.dev/gvisor/pkg/abi/linux"
+	"gvisor.dev/gvisor/pkg/fd"
+	"gvisor.dev/gvisor/pkg/p9"
+	"gvisor.dev/gvisor/pkg/sync"
+)
+
+// blockSize is the block size reported for files.
+const blockSize = 4096
+
+// attachPoint implements p9.Attacher for an image.
+type attachPoint struct {
+	img *Image
+}
+
+// NewAttachPoint returns a p9.Attacher that serves the files of img.
+func NewAttachPoint(img *Image) p9.Attacher {
+	return &attachPoint{img: img}
+}
+
+// Attach implements p9.Attacher.Attach.
+func (a *attachPoint) Attach() (p9.File, error) {
+	return &file{img: a.img, n: a.img.root}, nil
+}
+
+// file implements p9.File for a file of an image. All operations that would
+// modify the image fail with EROFS.
+type file struct {
+	p9.DefaultWalkGetAttr
+	p9.DisallowClientCalls
+
+	img *Image
+	n   *node
+
+	// mu protects opened.
+	mu     sync.Mutex
+	opened bool
+}
+
+var _ p9.File = (*file)(nil)
+
+func (f *file) qid() p9.QID {
+	return p9.QID{
+		Type: f.n.mode.QIDType(),
+		Path: f.n.ino,
+	}
+}
+
+func (f *file) isOpen() bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.opened
+}
+
+// Walk implements p9.File.Walk.
+func (f *file) Walk(names []string) ([]p9.QID, p9.File, error) {
+	n := f.n
+	qids := make([]p9.QID, 0, len(names))
+	for _, name := range names {
+		if n.children == nil {
+			return nil, nil, unix.ENOTDIR
+		}
+		child, ok := n.children[name]
+		if !ok {
+			return nil, nil, unix.ENOENT
+		}
+		n = child
+		qids = append(qids, p9.QID{Type: n.mode.QIDType(), Path: n.ino})
+	}
+	return qids, &file{img: f.img, n: n}, nil
+}
+
+// StatFS implements p9.File.StatFS.
+func (f *file) StatFS() (p9.FSStat, error) {
+	return p9.FSStat{
+		Type:       linux.V9FS_MAGIC,
+		BlockSize:  blockSize,
+		NameLength: linux.NAME_MAX,
+	}, nil
+}
+
+// GetAttr implements p9.File.GetAttr.
+func (f *file) GetAttr(p9.AttrMask) (p9.QID, p9.AttrMask, p9.Attr, error) {
+	n := f.n
+	sec := uint64(n.mtime.Unix())
+	nsec := uint64(n.mtime.Nanosecond())
+	attr := p9.Attr{
+		Mode:             n.mode,
+		UID:              p9.UID(n.uid),
+		GID:              p9.GID(n.gid),
+		NLink:            n.nlink,
+		RDev:             n.rdev,
+		Size:             n.size,
+		BlockSize:        blockSize,
+		Blocks:           (n.size + 511) / 512,
+		ATimeSeconds:     sec,
+		ATimeNanoSeconds: nsec,
+		MTimeSeconds:     sec,
+		MTimeNanoSeconds: nsec,
+		CTimeSeconds:     sec,
+		CTimeNanoSeconds: nsec,
+	}
+	valid := p9.AttrMask{
+		Mode:   true,
+		UID:    true,
+		GID:    true,
+		NLink:  true,
+		RDev:   true,
+		Size:   true,
+		Blocks: true,
+		ATime:  true,
+		MTime:  true,
+		CTime:  true,
+	}
+	return f.qid(), valid, attr, nil
+}
+
+// SetAttr implements p9.File.SetAttr.
+func (*file) SetAttr(p9.SetAttrMask, p9.SetAttr) error {
+	return unix.EROFS
+}
+
+// GetXattr implements p9.File.GetXattr.
+func (f *file) GetXattr(name string, size uint64) (string, error) {
+	value, ok := f.n.xattrs[name]
+	if !ok {
+		return "", unix.ENODATA
+	}
+	if size != 0 && uint64(len(value)) > size {
+		return "", unix.ERANGE
+	}
+	return value, nil
+}
+
+// SetXattr implements p9.File.SetXattr.
+func (*file) SetXattr(string, string, uint32) error {
+	return unix.EROFS
+}
+
+// ListXattr implements p9.File.ListXattr.
+func (f *file) ListXattr(size uint64) (map[string]struct{}, error) {
+	names := make(map[string]struct{}, len(f.n.xattrs))
+	listSize := uint64(0)
+	for name := range f.n.xattrs {
+		names[name] = struct{}{}
+		listSize += uint64(len(name)) + 1
+	}
+	if size != 0 && listSize > size {
+		return nil, unix.ERANGE
+	}
+	return names, nil
+}
+
+// RemoveXattr implements p9.File.RemoveXattr.
+func (*file) RemoveXattr(string) error {
+	return unix.EROFS
+}
+
+// Allocate implements p9.File.Allocate.
+func (*file) Allocate(p9.AllocateMode, uint64, uint64) error {
+	return unix.EROFS
+}
+
+// Close implements p9.File.Close.
+func (*file) Close() error {
+	return nil
+}
+
+// Open implements p9.File.Open. Files are read through ReadAt, since there
+// is no host file to donate.
+func (f *file) Open(flags p9.OpenFlags) (*fd.FD, p9.QID, uint32, error) {
+	if flags&p9.OpenFlagsModeMask != p9.ReadOnly || flags&p9.OpenTruncate != 0 {
+		return nil, p9.QID{}, 0, unix.EROFS
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.opened {
+		return nil, p9.QID{}, 0, unix.EBADF
+	}
+	f.opened = true
+	return nil, f.qid(), 0, nil
+}
+
+// ReadAt implements p9.File.ReadAt.
+func (f *file) ReadAt(p []byte, offset uint64) (int, error) {
+	if !f.isOpen() {
+		return 0, unix.EBADF
+	}
+	if !f.n.mode.IsRegular() {
+		return 0, unix.EINVAL
+	}
+	return f.img.readAt(f.n, p, offset)
+}
+
+// WriteAt implements p9.File.WriteAt.
+func (*file) WriteAt([]byte, uint64) (int, error) {
+	return 0, unix.EBADF
+}
+
+// FSync implements p9.File.FSync.
+func (*file) FSync() error {
+	return nil
+}
+
+// Create implements p9.File.Create.
+func (*file) Create(string, p9.OpenFlags, p9.FileMode, p9.UID, p9.GID) (*fd.FD, p9.File, p9.QID, uint32, error) {
+	return nil, nil, p9.QID{}, 0, unix.EROFS
+}
+
+// Mkdir implements p9.File.Mkdir.
+func (*file) Mkdir(string, p9.FileMode, p9.UID, p9.GID) (p9.QID, error) {
+	return p9.QID{}, unix.EROFS
+}
+
+// Symlink implements p9.File.Symlink.
+func (*file) Symlink(string, string, p9.UID, p9.GID) (p9.QID, error) {
+	return p9.QID{}, unix.EROFS
+}
+
+// Link implements p9.File.Link.
+func (*file) Link(p9.File, string) error {
+	return unix.EROFS
+}
+
+// Mknod implements p9.File.Mknod.
+func (*file) Mknod(string, p9.FileMode, uint32, uint32, p9.UID, p9.GID) (p9.QID, error) {
+	return p9.QID{}, unix.EROFS
+}
+
+// Rename implements p9.File.Rename.
+func (*file) Rename(p9.File, string) error {
+	return unix.EROFS
+}
+
+// RenameAt implements p9.File.RenameAt.
+func (*file) RenameAt(string, p9.File, string) error {
+	return unix.EROFS
+}
+
+// UnlinkAt implements p9.File.UnlinkAt.
+func (*file) UnlinkAt(string, uint32) error {
+	return unix.EROFS
+}
+
+// Readdir implements p9.File.Readdir.
+func (f *file) Readdir(offset uint64, count uint32) ([]p9.Dirent, error) {
+	if !f.isOpen() {
+		return nil, unix.EBADF
+	}
+	if f.n.children == nil {
+		return nil, unix.ENOTDIR
+	}
+	names := f.n.names
+	if offset >= uint64(len(names)) {
+		return nil, nil
+	}
+	names = names[offset:]
+	if uint64(len(names)) > uint64(count) {
+		names = names[:count]
+	}
+	dirents := make([]p9.Dirent, 0, len(names))
+	for i, name := range names {
+		child := f.n.children[name]
+		qid := p9.QID{Type: child.mode.QIDType(), Path: child.ino}
+		dirents = append(dirents, p9.Dirent{
+			QID:    qid,
+			Type:   qid.Type,
+			Name:   name,
+			Offset: offset + uint64(i) + 1,
+		})
+	}
+	return dirents, nil
+}
+
+// Readlink implements p9.File.Readlink.
+func (f *file) Readlink() (string, error) {
+	if !f.n.mode.IsSymlink() {
+		return "", unix.EINVAL
+	}
+	return f.n.target, nil
+}
+
+// Flush implements p9.File.Flush.
+func (*file) Flush() error {
+	return nil
+}
+
+// Connect implements p9.File.Connect.
+func (*file) Connect(p9.ConnectFlags) (*fd.FD, error) {
+	return nil, unix.ECONNREFUSED
+}
+
+// Renamed implements p9.File.Renamed.
+func (*file) Renamed(p9.File, string) {}
@@ -0,0 +1,460 @@
+// Copyright 2021 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package lazyimage serves the root filesystem of containers from images in
+// registries, fetching file contents on demand.
+//
+// Only images whose layers are in the eStargz format can be served: their
+// tables of contents are fetched when the image is opened, which is enough
+// to serve the image's directory tree, and the chunks of files are fetched
+// with range requests when they are first read. Chunks marked for
+// prefetching in the layers are fetched in the background. Fetched chunks
+// are cached in memory and, optionally, in a directory on disk shared by
+// sandboxes.
+//
+// Images are served read-only.
+package lazyimage
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"golang.org/x/sys/unix"
+	"gvisor.dev/gvisor/pkg/estargz"
+	"gvisor.dev/gvisor/pkg/log"
+	"gvisor.dev/gvisor/pkg/p9"
+)
+
+// Names of whiteout files, which delete files of lower layers.
+const (
+	whiteoutPrefix = ".wh."
+	opaqueWhiteout = ".wh..wh..opq"
+)
+
+// maxPrefetchRequest is the maximum number of bytes fetched by a single
+// request when prefetching.
+const maxPrefetchRequest = 16 << 20
+
+// Options configures how images are opened.
+type Options struct {
+	// CacheDir, if not empty, is the directory where fetched chunks are
+	// cached.
+	CacheDir string
+
+	// Transport is used for requests to the registry. If nil,
+	// http.DefaultTransport is used.
+	Transport http.RoundTripper
+}
+
+// Image is an image whose files are fetched on demand.
+type Image struct {
+	// ref is the reference of the image.
+	ref string
+
+	// layers are the image's layers, from the lowest.
+	layers []*layer
+
+	// root is the root directory.
+	root *node
+
+	// cache caches the chunks of files.
+	cache *chunkCache
+}
+
+// layer is a layer of an image.
+type layer struct {
+	blob *blob
+	toc  *estargz.TOC
+}
+
+// node is a file of an image, merged from its layers.
+type node struct {
+	// ino is the inode number of the file.
+	ino uint64
+
+	// mode is the type and permissions of the file.
+	mode p9.FileMode
+
+	uid   uint32
+	gid   uint32
+	size  uint64
+	rdev  uint64
+	mtime time.Time
+	nlink uint64
+
+	// target is the target of symlinks.
+	target string
+
+	xattrs map[string]string
+
+	// layer is the index of the layer that last defined the file.
+	layer int
+
+	// chunks are the chunks of regular files, in the blob of layer.
+	chunks []estargz.Chunk
+
+	// children are the entries of directories, by name.
+	children map[string]*node
+
+	// names are the names of children, in order.
+	names []string
+}
+
+// Open opens the image with the given reference, e.g.
+// "ghcr.io/stargz-containers/python:3.9-esgz", by fetching its manifest and
+// the tables of contents of its layers.
+func Open(ctx context.Context, ref string, opts Options) (*Image, error) {
+	r, err := parseReference(ref)
+	if err != nil {
+		return nil, err
+	}
+	transport := opts.Transport
+	if transport == nil {
+		transport = http.DefaultTransport
+	}
+	reg := newRegistry(r, transport)
+	tagOrDigest := r.digest
+	if tagOrDigest == "" {
+		tagOrDigest = r.tag
+	}
+	m, err := reg.fetchManifest(ctx, tagOrDigest)
+	if err != nil {
+		return nil, err
+	}
+	cache, err := newChunkCache(opts.CacheDir)
+	if err != nil {
+		return nil, err
+	}
+
+	img := &Image{
+		ref:   ref,
+		root:  newDir(0755),
+		cache: cache,
+	}
+	for i, d := range m.Layers {
+		if d.MediaType != mediaTypeOCILayer && d.MediaType != mediaTypeDockerLayer {
+			return nil, fmt.Errorf("layer %s has unsupported media type %q", d.Digest, d.MediaType)
+		}
+		l := &layer{blob: &blob{r: reg, digest: d.Digest, size: d.Size}}
+		if l.toc, err = estargz.ReadTOC(l.blob, d.Size); err != nil {
+			return nil, fmt.Errorf("layer %s isn't in the eStargz format: %v", d.Digest, err)
+		}
+		// The TOC isn't covered by the digest of the layer, so check it
+		// against the digest in the manifest, when there is one.
+		if want, ok := d.Annotations[estargz.TOCDigestAnnotation]; ok && want != l.toc.Digest {
+			return nil, fmt.Errorf("TOC of layer %s has digest %s, want %s", d.Digest, l.toc.Digest, want)
+		}
+		img.layers = append(img.layers, l)
+		if err := img.addLayer(i, l.toc); err != nil {
+			return nil, fmt.Errorf("layer %s: %v", d.Digest, err)
+		}
+	}
+	img.finalize()
+	log.Infof("Opened lazily loaded image %q with %d layers", ref, len(img.layers))
+	return img, nil
+}
+
+// newDir returns a new directory node.
+func newDir(perm p9.FileMode) *node {
+	return &node{
+		mode:     p9.ModeDirectory | perm,
+		children: make(map[string]*node),
+	}
+}
+
+// lookup returns the node at the given clean path, or nil if it doesn't
+// exist.
+func (img *Image) lookup(name string) *node {
+	n := img.root
+	if name == "" {
+		return n
+	}
+	for _, component := range strings.Split(name, "/") {
+		if n.children == nil {
+			return nil
+		}
+		if n = n.children[component]; n == nil {
+			return nil
+		}
+	}
+	return n
+}
+
+// mkdirAll returns the directory at the given clean path, creating it and its
+// parents as needed, as tar extraction does.
+func (img *Image) mkdirAll(name string, layer int) *node {
+	n := img.root
+	if name == "" {
+		return n
+	}
+	for _, component := range strings.Split(name, "/") {
+		child := n.children[component]
+		if child == nil || child.children == nil {
+			child = newDir(0755)
+			child.layer = layer
+			n.children[component] = child
+		}
+		n = child
+	}
+	return n
+}
+
+// addLayer merges the files of a layer with those of the lower layers.
+func (img *Image) addLayer(idx int, toc *estargz.TOC) error {
+	for _, e := range toc.Entries {
+		if e.Type == estargz.TypeChunk {
+			continue
+		}
+		name := estargz.CleanName(e.Name)
+		if name == estargz.PrefetchLandmark || name == estargz.NoPrefetchLandmark {
+			continue
+		}
+		if name == "" {
+			if e.Type == estargz.TypeDir {
+				img.root.setAttrs(e, idx)
+			}
+			continue
+		}
+		dir, base := path.Split(name)
+		parent := img.mkdirAll(strings.TrimSuffix(dir, "/"), idx)
+
+		switch {
+		case base == opaqueWhiteout:
+			// Hide the entries of lower layers.
+			for name, child := range parent.children {
+				if child.layer < idx {
+					delete(parent.children, name)
+				}
+			}
+			continue
+		case strings.HasPrefix(base, whiteoutPrefix):
+			delete(parent.children, base[len(whiteoutPrefix):])
+			continue
+		}
+
+		if e.Type == estargz.TypeHardlink {
+			target := img.lookup(estargz.CleanName(e.LinkName))
+			if target == nil || target.children != nil {
+				return fmt.Errorf("hard link %q has invalid target %q", name, e.LinkName)
+			}
+			parent.children[base] = target
+			continue
+		}
+
+		n := parent.children[base]
+		if e.Type == estargz.TypeDir && n != nil && n.children != nil {
+			// Directories of lower layers are merged with this one.
+			n.setAttrs(e, idx)
+			continue
+		}
+		n = &node{}
+		if e.Type == estargz.TypeDir {
+			n.children = make(map[string]*node)
+		}
+		if err := n.setType(e); err != nil {
+			return err
+		}
+		n.setAttrs(e, idx)
+		parent.children[base] = n
+	}
+	return nil
+}
+
+// setType sets the type and type-specific attributes of n from e.
+func (n *node) setType(e *estargz.Entry) error {
+	switch e.Type {
+	case estargz.TypeDir:
+		n.mode = p9.ModeDirectory
+	case estargz.TypeReg:
+		n.mode = p9.ModeRegular
+		n.size = uint64(e.Size)
+		n.chunks = e.Chunks()
+	case estargz.TypeSymlink:
+		n.mode = p9.ModeSymlink
+		n.target = e.LinkName
+		n.size = uint64(len(e.LinkName))
+	case estargz.TypeChar:
+		n.mode = p9.ModeCharacterDevice
+		n.rdev = unix.Mkdev(uint32(e.DevMajor), uint32(e.DevMinor))
+	case estargz.TypeBlock:
+		n.mode = p9.ModeBlockDevice
+		n.rdev = unix.Mkdev(uint32(e.DevMajor), uint32(e.DevMinor))
+	case estargz.TypeFIFO:
+		n.mode = p9.ModeNamedPipe
+	default:
+		return fmt.Errorf("entry %q has unknown type %q", e.Name, e.Type)
+	}
+	return nil
+}
+
+// setAttrs sets the permissions, owner, modification time and extended
+// attributes of n from e.
+func (n *node) setAttrs(e *estargz.Entry, layer int) {
+	n.mode = n.mode.FileType() | p9.FileMode(e.Mode&07777)
+	n.uid = uint32(e.UID)
+	n.gid = uint32(e.GID)
+	n.layer = layer
+	if t, err := time.Parse(time.RFC3339, e.ModTime3339); err == nil {
+		n.mtime = t
+	}
+	n.xattrs = nil
+	if len(e.Xattrs) > 0 {
+		n.xattrs = make(map[string]string, len(e.Xattrs))
+		for k, v := range e.Xattrs {
+			n.xattrs[k] = string(v)
+		}
+	}
+}
+
+// finalize assigns inode numbers and link counts, and sorts the entries of
+// directories, once all layers have been added.
+func (img *Image) finalize() {
+	nextIno := uint64(1)
+	var walk func(n *node)
+	walk = func(n *node) {
+		n.ino = nextIno
+		nextIno++
+		n.nlink = 2
+		n.names = make([]string, 0, len(n.children))
+		for name, child := range n.children {
+			n.names = append(n.names, name)
+			if child.children != nil {
+				n.nlink++
+				walk(child)
+				continue
+			}
+			// Hard links share their node, which is numbered once.
+			if child.nlink == 0 {
+				child.ino = nextIno
+				nextIno++
+			}
+			child.nlink++
+		}
+		sort.Strings(n.names)
+	}
+	walk(img.root)
+}
+
+// readAt reads the contents of the regular file n at off into p.
+func (img *Image) readAt(n *node, p []byte, off uint64) (int, error) {
+	if off >= n.size {
+		return 0, nil
+	}
+	if rem := n.size - off; uint64(len(p)) > rem {
+		p = p[:rem]
+	}
+	l := img.layers[n.layer]
+	i := sort.Search(len(n.chunks), func(i int) bool {
+		c := n.chunks[i]
+		return uint64(c.FileOffset+c.Size) > off
+	})
+	done := 0
+	for ; done < len(p) && i < len(n.chunks); i++ {
+		c := n.chunks[i]
+		data, err := img.chunk(l, c)
+		if err != nil {
+			log.Warningf("Reading image %q: %v", img.ref, err)
+			return done, unix.EIO
+		}
+		done += copy(p[done:], data[off+uint64(done)-uint64(c.FileOffset):])
+	}
+	return done, nil
+}
+
+// chunk returns the contents of a chunk of a file in l.
+func (img *Image) chunk(l *layer, c estargz.Chunk) ([]byte, error) {
+	return img.cachedChunk(l, c, func() ([]byte, error) {
+		compressed := make([]byte, c.CompressedSize)
+		if _, err := l.blob.ReadAt(compressed, c.Offset); err != nil {
+			return nil, err
+		}
+		return compressed, nil
+	})
+}
+
+// cachedChunk returns the contents of a chunk of a file in l from the cache.
+// If the chunk isn't cached, it decompresses the bytes returned by fetch.
+func (img *Image) cachedChunk(l *layer, c estargz.Chunk, fetch func() ([]byte, error)) ([]byte, error) {
+	key := chunkKey{blob: l.blob.digest, offset: c.Offset, innerOffset: c.InnerOffset}
+	verify := func(data []byte) error {
+		if int64(len(data)) != c.Size {
+			return fmt.Errorf("got %d bytes, want %d", len(data), c.Size)
+		}
+		return estargz.VerifyDigest(data, c.Digest)
+	}
+	return img.cache.get(key, func() ([]byte, error) {
+		compressed, err := fetch()
+		if err != nil {
+			return nil, err
+		}
+		return estargz.DecompressChunk(compressed, c)
+	}, verify)
+}
+
+// Prefetch fetches the chunks that the layers mark for prefetching, which
+// precede their prefetch landmark. It fetches them with as few requests as
+// possible, rather than one request per chunk.
+func (img *Image) Prefetch() {
+	for _, l := range img.layers {
+		end := l.toc.PrefetchOffset()
+		if end == 0 {
+			continue
+		}
+		var chunks []estargz.Chunk
+		for _, e := range l.toc.Entries {
+			for _, c := range e.Chunks() {
+				if c.Offset < end {
+					chunks = append(chunks, c)
+				}
+			}
+		}
+		sort.Slice(chunks, func(i, j int) bool { return chunks[i].Offset < chunks[j].Offset })
+		for len(chunks) > 0 {
+			n := 1
+			start := chunks[0].Offset
+			for n < len(chunks) && chunks[n].Offset+chunks[n].CompressedSize-start <= maxPrefetchRequest {
+				n++
+			}
+			if err := img.prefetchRange(l, chunks[:n]); err != nil {
+				log.Warningf("Prefetching layer %s of image %q: %v", l.blob.digest, img.ref, err)
+				break
+			}
+			chunks = chunks[n:]
+		}
+	}
+	log.Infof("Prefetched image %q", img.ref)
+}
+
+// prefetchRange fetches chunks, which are sorted by their offset, with one
+// request and caches them.
+func (img *Image) prefetchRange(l *layer, chunks []estargz.Chunk) error {
+	start := chunks[0].Offset
+	last := chunks[len(chunks)-1]
+	data := make([]byte, last.Offset+last.CompressedSize-start)
+	if _, err := l.blob.ReadAt(data, start); err != nil {
+		return err
+	}
+	for _, c := range chunks {
+		compressed := data[c.Offset-start : c.Offset-start+c.CompressedSize]
+		if _, err := img.cachedChunk(l, c, func() ([]byte, error) { return compressed, nil }); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,362 @@
+// Copyright 2021 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package lazyimage
+
+import (
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"gvisor.dev/gvisor/pkg/estargz"
+	"gvisor.dev/gvisor/pkg/p9"
+)
+
+func TestParseReference(t *testing.T) {
+	for _, tc := range []struct {
+		ref  string
+		want reference
+	}{
+		{
+			ref:  "ubuntu",
+			want: reference{host: "registry-1.docker.io", repository: "library/ubuntu", tag: "latest"},
+		},
+		{
+			ref:  "docker.io/stargz/python:3.9-esgz",
+			want: reference{host: "registry-1.docker.io", repository: "stargz/python", tag: "3.9-esgz"},
+		},
+		{
+			ref:  "ghcr.io/stargz-containers/node:17.8.0-esgz",
+			want: reference{host: "ghcr.io", repository: "stargz-containers/node", tag: "17.8.0-esgz"},
+		},
+		{
+			ref:  "localhost:5000/image@sha256:abcd",
+			want: reference{host: "localhost:5000", repository: "image", digest: "sha256:abcd"},
+		},
+		{
+			ref:  "localhost/a/b:tag@sha256:abcd",
+			want: reference{host: "localhost", repository: "a/b", tag: "tag", digest: "sha256:abcd"},
+		},
+	} {
+		got, err := parseReference(tc.ref)
+		if err != nil {
+			t.Errorf("parseReference(%q) failed: %v", tc.ref, err)
+			continue
+		}
+		if got != tc.want {
+			t.Errorf("parseReference(%q): got %+v, want %+v", tc.ref, got, tc.want)
+		}
+	}
+
+	for _, ref := range []string{"", "Ubuntu", "ubuntu@md5:abcd", "gcr.io/"} {
+		if _, err := parseReference(ref); err == nil {
+			t.Errorf("parseReference(%q) succeeded, want error", ref)
+		}
+	}
+}
+
+func TestParseChallenge(t *testing.T) {
+	got := parseChallenge(`realm="https://auth.docker.io/token",service="registry.docker.io", scope=repository:library/ubuntu:pull`)
+	want := map[string]string{
+		"realm":   "https://auth.docker.io/token",
+		"service": "registry.docker.io",
+		"scope":   "repository:library/ubuntu:pull",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("parseChallenge: got %v, want %v", got, want)
+	}
+}
+
+// names returns the paths of the files under n, with directories suffixed
+// with "/".
+func names(n *node, prefix string) []string {
+	var paths []string
+	for name, child := range n.children {
+		if child.children != nil {
+			paths = append(paths, prefix+name+"/")
+			paths = append(paths, names(child, prefix+name+"/")...)
+		} else {
+			paths = append(paths, prefix+name)
+		}
+	}
+	sort.Strings(paths)
+	return paths
+}
+
+func TestLayers(t *testing.T) {
+	layers := [][]*estargz.Entry{
+		{
+			{Name: "./", Type: estargz.TypeDir, Mode: 0755},
+			{Name: "bin/", Type: estargz.TypeDir, Mode: 0755},
+			{Name: "bin/sh", Type: estargz.TypeReg, Mode: 0755},
+			{Name: "bin/bash", Type: estargz.TypeHardlink, LinkName: "bin/sh"},
+			{Name: "etc/passwd", Type: estargz.TypeReg, Mode: 0644},
+			{Name: "etc/shadow", Type: estargz.TypeReg, Mode: 0600},
+			{Name: "var/lib/apt/lists/index", Type: estargz.TypeReg, Mode: 0644},
+			{Name: "dev/null", Type: estargz.TypeChar, Mode: 0666, DevMajor: 1, DevMinor: 3},
+		},
+		{
+			{Name: "etc/", Type: estargz.TypeDir, Mode: 0700, UID: 1},
+			{Name: "etc/.wh.shadow", Type: estargz.TypeReg},
+			{Name: "etc/hosts", Type: estargz.TypeReg, Mode: 0644},
+			{Name: "var/lib/apt/", Type: estargz.TypeDir, Mode: 0755},
+			{Name: "var/lib/apt/.wh..wh..opq", Type: estargz.TypeReg},
+			{Name: "var/lib/apt/new", Type: estargz.TypeReg, Mode: 0644},
+			{Name: "bin/sh", Type: estargz.TypeSymlink, LinkName: "bash", Mode: 0777},
+			{Name: estargz.NoPrefetchLandmark, Type: estargz.TypeReg},
+		},
+	}
+	img := &Image{root: newDir(0755)}
+	for i, entries := range layers {
+		if err := img.addLayer(i, &estargz.TOC{Version: 1, Entries: entries}); err != nil {
+			t.Fatalf("addLayer(%d) failed: %v", i, err)
+		}
+	}
+	img.finalize()
+
+	want := []string{
+		"bin/",
+		"bin/bash",
+		"bin/sh",
+		"dev/",
+		"dev/null",
+		"etc/",
+		"etc/hosts",
+		"etc/passwd",
+		"var/",
+		"var/lib/",
+		"var/lib/apt/",
+		"var/lib/apt/new",
+	}
+	if got := names(img.root, ""); !reflect.DeepEqual(got, want) {
+		t.Errorf("files: got %v, want %v", got, want)
+	}
+
+	etc := img.lookup("etc")
+	if etc.mode != p9.ModeDirectory|0700 || etc.uid != 1 {
+		t.Errorf("etc: got mode %#o, UID %d, want mode %#o, UID 1", etc.mode, etc.uid, p9.ModeDirectory|0700)
+	}
+	if sh := img.lookup("bin/sh"); !sh.mode.IsSymlink() || sh.target != "bash" {
+		t.Errorf("bin/sh: got mode %#o, target %q, want a symlink to bash", sh.mode, sh.target)
+	}
+	// The hard link keeps the file of the lower layer.
+	if bash := img.lookup("bin/bash"); !bash.mode.IsRegular() || bash.nlink != 1 {
+		t.Errorf("bin/bash: got mode %#o, %d links, want a regular file with 1 link", bash.mode, bash.nlink)
+	}
+	if null := img.lookup("dev/null"); !null.mode.IsCharacterDevice() || null.rdev == 0 {
+		t.Errorf("dev/null: got mode %#o, rdev %#x, want a character device", null.mode, null.rdev)
+	}
+	if got := img.root.nlink; got != 6 {
+		t.Errorf("root links: got %d, want 6", got)
+	}
+
+	// Inode numbers are unique.
+	inos := make(map[uint64]string)
+	var walk func(n *node, name string)
+	walk = func(n *node, name string) {
+		if other, ok := inos[n.ino]; ok && other != name {
+			t.Errorf("%q and %q have the same inode number %d", name, other, n.ino)
+		}
+		inos[n.ino] = name
+		for childName, child := range n.children {
+			walk(child, name+"/"+childName)
+		}
+	}
+	walk(img.root, "")
+}
+
+func TestFile(t *testing.T) {
+	img := &Image{root: newDir(0755)}
+	entries := []*estargz.Entry{
+		{Name: "dir/", Type: estargz.TypeDir, Mode: 0755},
+		{Name: "dir/b", Type: estargz.TypeReg, Mode: 0644, Xattrs: map[string][]byte{"user.k": []byte("v")}},
+		{Name: "dir/a", Type: estargz.TypeSymlink, LinkName: "b"},
+	}
+	if err := img.addLayer(0, &estargz.TOC{Version: 1, Entries: entries}); err != nil {
+		t.Fatalf("addLayer failed: %v", err)
+	}
+	img.finalize()
+
+	root, err := NewAttachPoint(img).Attach()
+	if err != nil {
+		t.Fatalf("Attach failed: %v", err)
+	}
+	qids, f, err := root.Walk([]string{"dir", "b"})
+	if err != nil {
+		t.Fatalf("Walk failed: %v", err)
+	}
+	if len(qids) != 2 || qids[0].Type != p9.TypeDir || qids[1].Type != p9.TypeRegular {
+		t.Errorf("Walk returned QIDs %+v", qids)
+	}
+	if value, err := f.GetXattr("user.k", 0); err != nil || value != "v" {
+		t.Errorf("GetXattr: got (%q, %v), want (\"v\", nil)", value, err)
+	}
+	if _, _, _, err := f.Open(p9.ReadWrite); err == nil {
+		t.Errorf("Open for writing succeeded")
+	}
+	if _, _, err := root.Walk([]string{"dir", "missing"}); err == nil {
+		t.Errorf("Walk to a missing file succeeded")
+	}
+
+	_, dir, err := root.Walk([]string{"dir"})
+	if err != nil {
+		t.Fatalf("Walk failed: %v", err)
+	}
+	if _, _, _, err := dir.Open(p9.ReadOnly); err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	dirents, err := dir.Readdir(0, 10)
+	if err != nil {
+		t.Fatalf("Readdir failed: %v", err)
+	}
+	if len(dirents) != 2 || dirents[0].Name != "a" || dirents[1].Name != "b" {
+		t.Errorf("Readdir: got %+v, want a and b", dirents)
+	}
+	dirents, err = dir.Readdir(dirents[0].Offset, 10)
+	if err != nil || len(dirents) != 1 || dirents[0].Name != "b" {
+		t.Errorf("Readdir from offset 1: got (%+v, %v), want b", dirents, err)
+	}
+}
+
+func TestFetcher(t *testing.T) {
+	data := strings.Repeat("0123456789", 1000)
+	mux := http.NewServeMux()
+	mux.HandleFunc("/blob", func(w http.ResponseWriter, r *http.Request) {
+		http.ServeContent(w, r, "blob", time.Time{}, strings.NewReader(data))
+	})
+	mux.HandleFunc("/stream", func(w http.ResponseWriter, r *http.Request) {
+		// The response has no Content-Length.
+		for i := 0; i < len(data); i += 1000 {
+			io.WriteString(w, data[i:i+1000])
+			w.(http.Flusher).Flush()
+		}
+	})
+	mux.Handle("/redirect", http.RedirectHandler("/blob", http.StatusFound))
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	var goferConns, fetcherConns []io.ReadWriteCloser
+	for i := 0; i < 2; i++ {
+		goferConn, fetcherConn := net.Pipe()
+		goferConns = append(goferConns, goferConn)
+		fetcherConns = append(fetcherConns, fetcherConn)
+	}
+	served := make(chan struct{})
+	go func() {
+		ServeFetcher(fetcherConns, http.DefaultTransport)
+		close(served)
+	}()
+	client := &http.Client{Transport: NewFetcherTransport(goferConns)}
+
+	get := func(path, rng string) (*http.Response, error) {
+		req, err := http.NewRequest(http.MethodGet, srv.URL+path, nil)
+		if err != nil {
+			t.Fatalf("NewRequest failed: %v", err)
+		}
+		if rng != "" {
+			req.Header.Set("Range", rng)
+		}
+		return client.Do(req)
+	}
+
+	// There are more requests than connections, so connections are reused.
+	for _, tc := range []struct {
+		path  string
+		rng   string
+		want  string
+		whole bool
+	}{
+		{path: "/blob", want: data, whole: true},
+		{path: "/blob", rng: "bytes=10-19", want: data[10:20], whole: true},
+		{path: "/stream", want: data, whole: true},
+		{path: "/redirect", rng: "bytes=5-9", want: data[5:10], whole: true},
+		// Closing a response that wasn't read completely doesn't break the
+		// connection.
+		{path: "/blob", want: data[:100]},
+		{path: "/stream", want: data[:100]},
+		{path: "/blob", rng: "bytes=100-", want: data[100:], whole: true},
+	} {
+		resp, err := get(tc.path, tc.rng)
+		if err != nil {
+			t.Fatalf("GET %s (range %q) failed: %v", tc.path, tc.rng, err)
+		}
+		var got []byte
+		if tc.whole {
+			got, err = ioutil.ReadAll(resp.Body)
+		} else {
+			got = make([]byte, len(tc.want))
+			_, err = io.ReadFull(resp.Body, got)
+		}
+		resp.Body.Close()
+		if err != nil {
+			t.Fatalf("reading GET %s (range %q) failed: %v", tc.path, tc.rng, err)
+		}
+		if string(got) != tc.want {
+			t.Errorf("GET %s (range %q) returned %d bytes, want %d bytes", tc.path, tc.rng, len(got), len(tc.want))
+		}
+	}
+
+	// Requests that the fetcher refuses or fails to send fail.
+	req, err := http.NewRequest(http.MethodPost, srv.URL+"/blob", strings.NewReader("data"))
+	if err != nil {
+		t.Fatalf("NewRequest failed: %v", err)
+	}
+	if resp, err := client.Do(req); err == nil {
+		resp.Body.Close()
+		t.Errorf("POST succeeded, want error")
+	}
+	if resp, err := client.Get("http://127.0.0.1:0/blob"); err == nil {
+		resp.Body.Close()
+		t.Errorf("GET of an unreachable server succeeded, want error")
+	}
+	if resp, err := get("/blob", ""); err != nil {
+		t.Errorf("GET after failed requests failed: %v", err)
+	} else {
+		resp.Body.Close()
+	}
+
+	// The fetcher exits once the gofer closes its connections.
+	for _, conn := range goferConns {
+		conn.Close()
+	}
+	select {
+	case <-served:
+	case <-time.After(10 * time.Second):
+		t.Errorf("ServeFetcher didn't return after the connections were closed")
+	}
+}
+
+func TestCheckRegistryAddr(t *testing.T) {
+	for _, tc := range []struct {
+		addr string
+		ok   bool
+	}{
+		{"127.0.0.1:5000", true},
+		{"203.0.113.1:443", true},
+		{"[2001:db8::1]:443", true},
+		{"169.254.169.254:80", false},
+		{"[fe80::1]:80", false},
+	} {
+		if err := checkRegistryAddr("tcp", tc.addr, nil); (err == nil) != tc.ok {
+			t.Errorf("checkRegistryAddr(%q) = %v, want ok: %t", tc.addr, err, tc.ok)
+		}
+	}
+}
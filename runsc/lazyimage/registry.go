@@ -0,0 +1,435 @@
+// Copyright 2021 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package lazyimage
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"runtime"
+	"strings"
+	"syscall"
+	"time"
+
+	"gvisor.dev/gvisor/pkg/sync"
+)
+
+// Media types of manifests.
+const (
+	mediaTypeDockerManifest     = "application/vnd.docker.distribution.manifest.v2+json"
+	mediaTypeDockerManifestList = "application/vnd.docker.distribution.manifest.list.v2+json"
+	mediaTypeOCIManifest        = "application/vnd.oci.image.manifest.v1+json"
+	mediaTypeOCIIndex           = "application/vnd.oci.image.index.v1+json"
+)
+
+// Media types of gzip-compressed layers, which may be in the eStargz format.
+const (
+	mediaTypeDockerLayer = "application/vnd.docker.image.rootfs.diff.tar.gzip"
+	mediaTypeOCILayer    = "application/vnd.oci.image.layer.v1.tar+gzip"
+)
+
+// maxManifestSize is the maximum size of manifests.
+const maxManifestSize = 4 << 20
+
+// descriptor describes a manifest or blob.
+type descriptor struct {
+	MediaType   string            `json:"mediaType"`
+	Digest      string            `json:"digest"`
+	Size        int64             `json:"size"`
+	Annotations map[string]string `json:"annotations,omitempty"`
+	Platform    *platform         `json:"platform,omitempty"`
+}
+
+// platform is the platform of a manifest in an index.
+type platform struct {
+	Architecture string `json:"architecture"`
+	OS           string `json:"os"`
+}
+
+// manifest is an image manifest or an index of manifests.
+type manifest struct {
+	MediaType string       `json:"mediaType"`
+	Layers    []descriptor `json:"layers"`
+	Manifests []descriptor `json:"manifests"`
+}
+
+// reference is a parsed image reference.
+type reference struct {
+	// host is the host of the registry, with an optional port.
+	host string
+
+	// repository is the name of the image's repository.
+	repository string
+
+	// tag is the tag of the image, used if digest is empty.
+	tag string
+
+	// digest is the digest of the image's manifest.
+	digest string
+}
+
+// parseReference parses references such as "ubuntu", "gcr.io/project/image:tag"
+// or "registry:5000/image@sha256:<hex>". Images with no registry are on
+// Docker Hub.
+func parseReference(ref string) (reference, error) {
+	var r reference
+	name := ref
+	if i := strings.Index(name, "@"); i >= 0 {
+		name, r.digest = name[:i], name[i+1:]
+		if !strings.HasPrefix(r.digest, "sha256:") {
+			return r, fmt.Errorf("image reference %q has unsupported digest", ref)
+		}
+	}
+	if i := strings.LastIndex(name, ":"); i > strings.LastIndex(name, "/") {
+		name, r.tag = name[:i], name[i+1:]
+	}
+	if r.tag == "" && r.digest == "" {
+		r.tag = "latest"
+	}
+	if i := strings.Index(name, "/"); i >= 0 && (strings.ContainsAny(name[:i], ".:") || name[:i] == "localhost") {
+		r.host, r.repository = name[:i], name[i+1:]
+	} else {
+		r.host, r.repository = "docker.io", name
+	}
+	if r.repository == "" || r.repository != strings.ToLower(r.repository) {
+		return r, fmt.Errorf("image reference %q has invalid repository", ref)
+	}
+	if r.host == "docker.io" {
+		r.host = "registry-1.docker.io"
+		if !strings.Contains(r.repository, "/") {
+			r.repository = "library/" + r.repository
+		}
+	}
+	return r, nil
+}
+
+// NewTransport returns a transport for requests to registries, for use by the
+// fetcher. The DNS configuration and the CA certificates of the host are read
+// when it is created, so that problems with them are reported at startup.
+func NewTransport() (*http.Transport, error) {
+	roots, err := x509.SystemCertPool()
+	if err != nil {
+		return nil, fmt.Errorf("loading CA certificates: %v", err)
+	}
+	resolver := &net.Resolver{PreferGo: true}
+	if servers := nameservers("/etc/resolv.conf"); len(servers) > 0 {
+		var d net.Dialer
+		resolver.Dial = func(ctx context.Context, network, _ string) (net.Conn, error) {
+			var err error
+			for _, server := range servers {
+				var c net.Conn
+				if c, err = d.DialContext(ctx, network, net.JoinHostPort(server, "53")); err == nil {
+					return c, nil
+				}
+			}
+			return nil, err
+		}
+	}
+	dialer := &net.Dialer{
+		Timeout:   30 * time.Second,
+		KeepAlive: 30 * time.Second,
+		Resolver:  resolver,
+		Control:   checkRegistryAddr,
+	}
+	return &http.Transport{
+		Proxy:               http.ProxyFromEnvironment,
+		DialContext:         dialer.DialContext,
+		TLSClientConfig:     &tls.Config{RootCAs: roots},
+		TLSHandshakeTimeout: 10 * time.Second,
+		IdleConnTimeout:     90 * time.Second,
+		MaxIdleConnsPerHost: 8,
+		ForceAttemptHTTP2:   true,
+	}, nil
+}
+
+// checkRegistryAddr implements net.Dialer.Control. It keeps registries from
+// being at link-local addresses, where cloud providers serve the metadata and
+// credentials of hosts.
+func checkRegistryAddr(network, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	if ip := net.ParseIP(host); ip == nil || ip.IsLinkLocalUnicast() {
+		return fmt.Errorf("connecting to %s isn't allowed", address)
+	}
+	return nil
+}
+
+// nameservers returns the name servers in the resolv.conf file at path.
+func nameservers(path string) []string {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil
+	}
+	var servers []string
+	for _, line := range strings.Split(string(data), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) >= 2 && fields[0] == "nameserver" {
+			servers = append(servers, fields[1])
+		}
+	}
+	return servers
+}
+
+// registry is a client for the repository of an image in a registry that
+// implements the OCI distribution API.
+type registry struct {
+	client *http.Client
+
+	// base is the URL of the repository's API, e.g.
+	// https://gcr.io/v2/project/image.
+	base string
+
+	// repository is the name of the repository.
+	repository string
+
+	// mu protects token.
+	mu sync.Mutex
+
+	// token is the bearer token used to authenticate requests, if the
+	// registry requires one.
+	token string
+}
+
+func newRegistry(ref reference, transport http.RoundTripper) *registry {
+	return &registry{
+		client:     &http.Client{Transport: transport},
+		base:       "https://" + ref.host + "/v2/" + ref.repository,
+		repository: ref.repository,
+	}
+}
+
+// do sends req, authenticating with an anonymous bearer token if the
+// registry requires one.
+func (r *registry) do(req *http.Request) (*http.Response, error) {
+	r.mu.Lock()
+	token := r.token
+	r.mu.Unlock()
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusUnauthorized {
+		return resp, nil
+	}
+	challenge := resp.Header.Get("WWW-Authenticate")
+	resp.Body.Close()
+	token, err = r.fetchToken(req.Context(), challenge)
+	if err != nil {
+		return nil, err
+	}
+	r.mu.Lock()
+	r.token = token
+	r.mu.Unlock()
+	retry := req.Clone(req.Context())
+	retry.Header.Set("Authorization", "Bearer "+token)
+	return r.client.Do(retry)
+}
+
+// fetchToken fetches a token for pulling from the repository from the
+// authorization server given by the challenge of an unauthorized response.
+func (r *registry) fetchToken(ctx context.Context, challenge string) (string, error) {
+	const scheme = "bearer "
+	if !strings.HasPrefix(strings.ToLower(challenge), scheme) {
+		return "", fmt.Errorf("registry requires unsupported authentication %q", challenge)
+	}
+	params := parseChallenge(challenge[len(scheme):])
+	realm, err := url.Parse(params["realm"])
+	if err != nil || realm.Host == "" {
+		return "", fmt.Errorf("invalid authentication realm in %q", challenge)
+	}
+	q := realm.Query()
+	if service, ok := params["service"]; ok {
+		q.Set("service", service)
+	}
+	q.Set("scope", "repository:"+r.repository+":pull")
+	realm.RawQuery = q.Encode()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, realm.String(), nil)
+	if err != nil {
+		return "", err
+	}
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("fetching token: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("fetching token: %s", resp.Status)
+	}
+	var body struct {
+		Token       string `json:"token"`
+		AccessToken string `json:"access_token"`
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, maxManifestSize)).Decode(&body); err != nil {
+		return "", fmt.Errorf("decoding token: %v", err)
+	}
+	if body.Token != "" {
+		return body.Token, nil
+	}
+	if body.AccessToken != "" {
+		return body.AccessToken, nil
+	}
+	return "", fmt.Errorf("authorization server returned no token")
+}
+
+// parseChallenge parses the comma-separated key="value" parameters of an
+// authentication challenge.
+func parseChallenge(s string) map[string]string {
+	params := make(map[string]string)
+	for s != "" {
+		i := strings.Index(s, "=")
+		if i < 0 {
+			break
+		}
+		key := strings.ToLower(strings.TrimSpace(s[:i]))
+		s = s[i+1:]
+		var value string
+		if strings.HasPrefix(s, `"`) {
+			end := strings.Index(s[1:], `"`)
+			if end < 0 {
+				break
+			}
+			value, s = s[1:end+1], s[end+2:]
+		} else if end := strings.Index(s, ","); end >= 0 {
+			value, s = s[:end], s[end:]
+		} else {
+			value, s = s, ""
+		}
+		params[key] = strings.TrimSpace(value)
+		s = strings.TrimLeft(s, ", ")
+	}
+	return params
+}
+
+// fetchManifest fetches the manifest with the given tag or digest. Indexes
+// are resolved to the manifest of the current platform.
+func (r *registry) fetchManifest(ctx context.Context, tagOrDigest string) (*manifest, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, r.base+"/manifests/"+tagOrDigest, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", strings.Join([]string{
+		mediaTypeOCIIndex,
+		mediaTypeOCIManifest,
+		mediaTypeDockerManifestList,
+		mediaTypeDockerManifest,
+	}, ", "))
+	resp, err := r.do(req)
+	if err != nil {
+		return nil, fmt.Errorf("fetching manifest %q: %v", tagOrDigest, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("fetching manifest %q: %s", tagOrDigest, resp.Status)
+	}
+	data, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxManifestSize+1))
+	if err != nil {
+		return nil, fmt.Errorf("fetching manifest %q: %v", tagOrDigest, err)
+	}
+	if len(data) > maxManifestSize {
+		return nil, fmt.Errorf("manifest %q is too large", tagOrDigest)
+	}
+	if strings.HasPrefix(tagOrDigest, "sha256:") {
+		sum := sha256.Sum256(data)
+		if got := "sha256:" + hex.EncodeToString(sum[:]); got != tagOrDigest {
+			return nil, fmt.Errorf("manifest %q has digest %q", tagOrDigest, got)
+		}
+	}
+	m := &manifest{}
+	if err := json.Unmarshal(data, m); err != nil {
+		return nil, fmt.Errorf("parsing manifest %q: %v", tagOrDigest, err)
+	}
+	if m.MediaType == "" {
+		m.MediaType = resp.Header.Get("Content-Type")
+	}
+
+	switch m.MediaType {
+	case mediaTypeOCIManifest, mediaTypeDockerManifest:
+		return m, nil
+	case mediaTypeOCIIndex, mediaTypeDockerManifestList:
+		for _, d := range m.Manifests {
+			if d.Platform != nil && d.Platform.OS == "linux" && d.Platform.Architecture == runtime.GOARCH {
+				return r.fetchManifest(ctx, d.Digest)
+			}
+		}
+		return nil, fmt.Errorf("image %q has no manifest for linux/%s", tagOrDigest, runtime.GOARCH)
+	default:
+		return nil, fmt.Errorf("manifest %q has unsupported media type %q", tagOrDigest, m.MediaType)
+	}
+}
+
+// blob reads a blob of the repository with range requests. It implements
+// io.ReaderAt.
+type blob struct {
+	r      *registry
+	digest string
+	size   int64
+}
+
+// ReadAt implements io.ReaderAt.ReadAt.
+func (b *blob) ReadAt(p []byte, off int64) (int, error) {
+	if off >= b.size {
+		return 0, io.EOF
+	}
+	want := p
+	if rem := b.size - off; int64(len(want)) > rem {
+		want = want[:rem]
+	}
+	if len(want) == 0 {
+		return 0, nil
+	}
+	req, err := http.NewRequest(http.MethodGet, b.r.base+"/blobs/"+b.digest, nil)
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", off, off+int64(len(want))-1))
+	resp, err := b.r.do(req)
+	if err != nil {
+		return 0, fmt.Errorf("fetching blob %s: %v", b.digest, err)
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusPartialContent:
+	case http.StatusOK:
+		// The registry ignored the range and returned the whole blob.
+		if _, err := io.CopyN(ioutil.Discard, resp.Body, off); err != nil {
+			return 0, fmt.Errorf("fetching blob %s: %v", b.digest, err)
+		}
+	default:
+		return 0, fmt.Errorf("fetching blob %s: %s", b.digest, resp.Status)
+	}
+	n, err := io.ReadFull(resp.Body, want)
+	if err != nil {
+		return n, fmt.Errorf("fetching blob %s: %v", b.digest, err)
+	}
+	if len(want) < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
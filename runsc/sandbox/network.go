@@ -63,7 +63,7 @@ func setupNetwork(conn *urpc.Client, pid int, spec *specs.Spec, conf *config.Con
 		// Build the path to the net namespace of the sandbox process.
 		// This is what we will copy.
 		nsPath := filepath.Join("/proc", strconv.Itoa(pid), "ns/net")
-		if err := createInterfacesAndRoutesFromNS(conn, nsPath, conf.HardwareGSO, conf.SoftwareGSO, conf.TXChecksumOffload, conf.RXChecksumOffload, conf.NumNetworkChannels, conf.QDisc); err != nil {
+		if err := createInterfacesAndRoutesFromNS(conn, nsPath, conf.HardwareGSO, conf.SoftwareGSO, conf.TXChecksumOffload, conf.RXChecksumOffload, conf.NumNetworkChannels, conf.QDisc, conf.TCPCheckpoint); err != nil {
 			return fmt.Errorf("creating interfaces from net namespace %q: %v", nsPath, err)
 		}
 	case config.NetworkHost:
@@ -116,7 +116,7 @@ func isRootNS() (bool, error) {
 // createInterfacesAndRoutesFromNS scrapes the interface and routes from the
 // net namespace with the given path, creates them in the sandbox, and removes
 // them from the host.
-func createInterfacesAndRoutesFromNS(conn *urpc.Client, nsPath string, hardwareGSO bool, softwareGSO bool, txChecksumOffload bool, rxChecksumOffload bool, numNetworkChannels int, qDisc config.QueueingDiscipline) error {
+func createInterfacesAndRoutesFromNS(conn *urpc.Client, nsPath string, hardwareGSO bool, softwareGSO bool, txChecksumOffload bool, rxChecksumOffload bool, numNetworkChannels int, qDisc config.QueueingDiscipline, saveRestore bool) error {
 	// Join the network namespace that we will be copying.
 	restore, err := joinNetNS(nsPath)
 	if err != nil {
@@ -204,6 +204,7 @@ func createInterfacesAndRoutesFromNS(conn *urpc.Client, nsPath string, hardwareG
 			RXChecksumOffload: rxChecksumOffload,
 			NumChannels:       numNetworkChannels,
 			QDisc:             qDisc,
+			SaveRestore:       saveRestore,
 		}
 
 		// Get the link for the interface.
@@ -213,23 +214,41 @@ func createInterfacesAndRoutesFromNS(conn *urpc.Client, nsPath string, hardwareG
 		}
 		link.LinkAddress = ifaceLink.Attrs().HardwareAddr
 
+		// ipvlan devices in L3 modes don't forward ARP and NDP
+		// messages, and ignore the destination address of outbound
+		// frames.
+		if l, ok := ifaceLink.(*netlink.IPVlan); ok && l.Mode != netlink.IPVLAN_MODE_L2 {
+			log.Infof("Disabling link address resolution on L3 mode ipvlan interface %q", iface.Name)
+			link.ResolutionDisabled = true
+		}
+
+		_, isMacvtap := ifaceLink.(*netlink.Macvtap)
+
 		log.Debugf("Setting up network channels")
 		// Create the socket for the device.
 		for i := 0; i < link.NumChannels; i++ {
 			log.Debugf("Creating Channel %d", i)
-			socketEntry, err := createSocket(iface, ifaceLink, hardwareGSO)
+			var entry *socketEntry
+			if isMacvtap {
+				// Frames are read and written directly through
+				// the queues of macvtap devices instead of an
+				// AF_PACKET socket.
+				entry, err = openMacvtapQueue(iface)
+			} else {
+				entry, err = createSocket(iface, ifaceLink, hardwareGSO)
+			}
 			if err != nil {
 				return fmt.Errorf("failed to createSocket for %s : %w", iface.Name, err)
 			}
 			if i == 0 {
-				link.GSOMaxSize = socketEntry.gsoMaxSize
+				link.GSOMaxSize = entry.gsoMaxSize
 			} else {
-				if link.GSOMaxSize != socketEntry.gsoMaxSize {
+				if link.GSOMaxSize != entry.gsoMaxSize {
 					return fmt.Errorf("inconsistent gsoMaxSize %d and %d when creating multiple channels for same interface: %s",
-						link.GSOMaxSize, socketEntry.gsoMaxSize, iface.Name)
+						link.GSOMaxSize, entry.gsoMaxSize, iface.Name)
 				}
 			}
-			args.FilePayload.Files = append(args.FilePayload.Files, socketEntry.deviceFile)
+			args.FilePayload.Files = append(args.FilePayload.Files, entry.deviceFile)
 		}
 
 		if link.GSOMaxSize == 0 && softwareGSO {
@@ -327,6 +346,28 @@ func createSocket(iface net.Interface, ifaceLink netlink.Link, enableGSO bool) (
 	return &socketEntry{deviceFile, gsoMaxSize}, nil
 }
 
+// openMacvtapQueue opens a new queue of the macvtap device iface and returns
+// an *os.File that wraps the queue fd. Each queue is a separate channel of the
+// device, like AF_PACKET sockets in a fanout group.
+func openMacvtapQueue(iface net.Interface) (*socketEntry, error) {
+	// The character device of a macvtap device is named after its index.
+	path := fmt.Sprintf("/dev/tap%d", iface.Index)
+	fd, err := syscall.Open(path, syscall.O_RDWR|syscall.O_CLOEXEC, 0)
+	if err != nil {
+		return nil, fmt.Errorf("unable to open macvtap device %q: %v", path, err)
+	}
+	deviceFile := os.NewFile(uintptr(fd), "macvtap-device-fd")
+
+	// Queues are opened with IFF_VNET_HDR set, clear it since the virtio
+	// net header is only used for hardware GSO, which isn't enabled on
+	// macvtap devices.
+	if err := setTapFlags(fd, unix.IFF_TAP|unix.IFF_NO_PI); err != nil {
+		deviceFile.Close()
+		return nil, fmt.Errorf("unable to set flags of macvtap device %q: %v", path, err)
+	}
+	return &socketEntry{deviceFile, 0}, nil
+}
+
 // loopbackLink returns the link with addresses and routes for a loopback
 // interface.
 func loopbackLink(iface net.Interface, addrs []net.Addr) (boot.LoopbackLink, error) {
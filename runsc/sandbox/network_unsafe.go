@@ -54,3 +54,18 @@ func isGSOEnabled(fd int, intf string) (bool, error) {
 
 	return val.val != 0, nil
 }
+
+// setTapFlags sets the flags of the tap device queue fd.
+func setTapFlags(fd int, flags uint16) error {
+	var ifr struct {
+		name  [unix.IFNAMSIZ]byte
+		flags uint16
+		_     [22]byte
+	}
+	ifr.flags = flags
+
+	if _, _, err := syscall.Syscall(syscall.SYS_IOCTL, uintptr(fd), unix.TUNSETIFF, uintptr(unsafe.Pointer(&ifr))); err != 0 {
+		return err
+	}
+	return nil
+}
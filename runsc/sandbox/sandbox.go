@@ -19,9 +19,11 @@ import (
 	"context"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"math"
 	"os"
 	"os/exec"
+	"path/filepath"
 	"strconv"
 	"strings"
 	"syscall"
@@ -36,10 +38,12 @@ import (
 	"gvisor.dev/gvisor/pkg/log"
 	"gvisor.dev/gvisor/pkg/sentry/control"
 	"gvisor.dev/gvisor/pkg/sentry/platform"
+	"gvisor.dev/gvisor/pkg/sentry/watchdog"
+	"gvisor.dev/gvisor/pkg/state/statefile"
 	"gvisor.dev/gvisor/pkg/sync"
 	"gvisor.dev/gvisor/pkg/urpc"
 	"gvisor.dev/gvisor/runsc/boot"
-	"gvisor.dev/gvisor/runsc/boot/platforms"
+	_ "gvisor.dev/gvisor/runsc/boot/platforms" // register all platforms.
 	"gvisor.dev/gvisor/runsc/cgroup"
 	"gvisor.dev/gvisor/runsc/config"
 	"gvisor.dev/gvisor/runsc/console"
@@ -70,6 +74,10 @@ type Sandbox struct {
 	// started, before it may be modified.
 	OriginalOOMScoreAdj int `json:"originalOomScoreAdj"`
 
+	// ExitFile is the path to the file to which the sandbox process writes
+	// its exit status when it exits. It may be empty.
+	ExitFile string `json:"exitFile,omitempty"`
+
 	// child is set if a sandbox process is a child of the current process.
 	//
 	// This field isn't saved to json, because only a creator of sandbox
@@ -120,12 +128,21 @@ type Args struct {
 	// Attached indicates that the sandbox lifecycle is attached with the caller.
 	// If the caller exits, the sandbox should exit too.
 	Attached bool
+
+	// ExitFile is the path to the file to which the sandbox process writes
+	// its exit status, so that it's available to processes other than its
+	// parent. It may be empty.
+	ExitFile string
+
+	// ListenFiles are the files passed to the container by socket activation.
+	// They appear in the container starting at FD 3.
+	ListenFiles []*os.File
 }
 
 // New creates the sandbox process. The caller must call Destroy() on the
 // sandbox.
 func New(conf *config.Config, args *Args) (*Sandbox, error) {
-	s := &Sandbox{ID: args.ID, Cgroup: args.Cgroup}
+	s := &Sandbox{ID: args.ID, Cgroup: args.Cgroup, ExitFile: args.ExitFile}
 	// The Cleanup object cleans up partially created sandboxes when an error
 	// occurs. Any errors occurring during cleanup itself are ignored.
 	c := cleanup.Make(func() {
@@ -262,6 +279,8 @@ func (s *Sandbox) Restore(cid string, spec *specs.Spec, conf *config.Config, fil
 			Files: []*os.File{rf},
 		},
 		SandboxID: s.ID,
+		Migration: conf.RestoreMigration,
+		Key:       conf.RestoreKey,
 	}
 
 	// If the platform needs a device FD we must pass it in.
@@ -308,6 +327,129 @@ func (s *Sandbox) Processes(cid string) ([]*control.Process, error) {
 	return pl, nil
 }
 
+// Prefetch reads the files at the given paths in the container, recursing into
+// directories. It returns the number of files read.
+func (s *Sandbox) Prefetch(cid string, paths []string) (int, error) {
+	log.Debugf("Prefetching %d paths in container %q in sandbox %q", len(paths), cid, s.ID)
+	conn, err := s.sandboxConnect()
+	if err != nil {
+		return 0, err
+	}
+	defer conn.Close()
+
+	args := boot.PrefetchArgs{
+		CID:   cid,
+		Paths: paths,
+	}
+	var files int
+	if err := conn.Call(boot.ContainerPrefetch, &args, &files); err != nil {
+		return 0, fmt.Errorf("prefetching files in container %q: %v", cid, err)
+	}
+	return files, nil
+}
+
+// minCPUs is the minimum number of CPUs derived from the CPU quota. Dropping
+// below 2 CPUs can trigger application to disable locks that can lead do hard
+// to debug errors, so just leaving two cores as reasonable default.
+const minCPUs = 2
+
+// Resize changes the number of CPUs and the amount of memory visible to
+// applications in the sandbox. Zero values leave the corresponding resource
+// unchanged.
+func (s *Sandbox) Resize(cpus uint, totalMem uint64) error {
+	return s.resize(&boot.ResizeArgs{
+		CPUs:     cpus,
+		TotalMem: totalMem,
+	})
+}
+
+// ResizeToResources resizes the sandbox to res, the resources of all of its
+// containers. The memory visible to applications is the memory limit. As when
+// the sandbox is started, the number of CPUs follows the CPU quota only if
+// --cpu-num-from-quota is set. It is capped at the maximum number of CPUs of
+// the sandbox.
+func (s *Sandbox) ResizeToResources(conf *config.Config, res *specs.LinuxResources) error {
+	args := boot.ResizeArgs{ClampCPUs: true}
+	if cpu := res.CPU; conf.CPUNumFromQuota && cpu != nil && cpu.Quota != nil && *cpu.Quota > 0 && cpu.Period != nil && *cpu.Period > 0 {
+		n := uint(math.Ceil(float64(*cpu.Quota) / float64(*cpu.Period)))
+		if n < minCPUs {
+			n = minCPUs
+		}
+		args.CPUs = n
+	}
+	if mem := res.Memory; mem != nil && mem.Limit != nil && *mem.Limit > 0 {
+		args.TotalMem = uint64(*mem.Limit)
+	}
+	if args.CPUs == 0 && args.TotalMem == 0 {
+		return nil
+	}
+	return s.resize(&args)
+}
+
+func (s *Sandbox) resize(args *boot.ResizeArgs) error {
+	log.Debugf("Resizing sandbox %q to %d CPUs, %d bytes of memory", s.ID, args.CPUs, args.TotalMem)
+	conn, err := s.sandboxConnect()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	if err := conn.Call(boot.SandboxResize, args, nil); err != nil {
+		return fmt.Errorf("resizing sandbox %q: %v", s.ID, err)
+	}
+	return nil
+}
+
+// FSStats returns the file operation statistics of the mounts in the given
+// container.
+func (s *Sandbox) FSStats(cid string) (string, error) {
+	log.Debugf("FSStats of container %q in sandbox %q", cid, s.ID)
+	conn, err := s.sandboxConnect()
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+
+	var stats string
+	if err := conn.Call(boot.ContainerFSStats, &cid, &stats); err != nil {
+		return "", fmt.Errorf("getting file system statistics of container %q: %v", cid, err)
+	}
+	return stats, nil
+}
+
+// SyscallStats returns the syscall latency histograms of the sandbox and up to
+// n of its slowest recent syscalls.
+func (s *Sandbox) SyscallStats(n int) (string, error) {
+	log.Debugf("SyscallStats of sandbox %q", s.ID)
+	conn, err := s.sandboxConnect()
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+
+	var stats string
+	if err := conn.Call(boot.ContainerSyscallStats, &n, &stats); err != nil {
+		return "", fmt.Errorf("getting syscall statistics: %v", err)
+	}
+	return stats, nil
+}
+
+// MemoryBreakdown returns the attribution of the sandbox's memory usage.
+func (s *Sandbox) MemoryBreakdown() (*boot.MemoryBreakdown, error) {
+	log.Debugf("MemoryBreakdown of sandbox %q", s.ID)
+	conn, err := s.sandboxConnect()
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	var mb boot.MemoryBreakdown
+	if err := conn.Call(boot.ContainerMemoryBreakdown, nil, &mb); err != nil {
+		return nil, fmt.Errorf("getting memory breakdown: %v", err)
+	}
+	return &mb, nil
+}
+
 // Execute runs the specified command in the container. It returns the PID of
 // the newly created process.
 func (s *Sandbox) Execute(args *control.ExecArgs) (int32, error) {
@@ -336,15 +478,29 @@ func (s *Sandbox) Event(cid string) (*boot.Event, error) {
 	defer conn.Close()
 
 	var e boot.Event
-	// TODO(b/129292330): Pass in the container id (cid) here. The sandbox
-	// should return events only for that container.
-	if err := conn.Call(boot.ContainerEvent, nil, &e); err != nil {
+	if err := conn.Call(boot.ContainerEvent, &cid, &e); err != nil {
 		return nil, fmt.Errorf("retrieving event data from sandbox: %v", err)
 	}
-	e.ID = cid
 	return &e, nil
 }
 
+// StallEvents returns the stalls detected by the sandbox's watchdog after the
+// one numbered after.
+func (s *Sandbox) StallEvents(after uint64) ([]watchdog.StallEvent, error) {
+	log.Debugf("Getting stall events of sandbox %q", s.ID)
+	conn, err := s.sandboxConnect()
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	var events []watchdog.StallEvent
+	if err := conn.Call(boot.ContainerStallEvents, &after, &events); err != nil {
+		return nil, fmt.Errorf("retrieving stall events from sandbox: %v", err)
+	}
+	return events, nil
+}
+
 func (s *Sandbox) sandboxConnect() (*urpc.Client, error) {
 	log.Debugf("Connecting to sandbox %q", s.ID)
 	conn, err := client.ConnectTo(boot.ControlSocketAddr(s.ID))
@@ -477,9 +633,169 @@ func (s *Sandbox) createSandboxProcess(conf *config.Config, args *Args, startSyn
 		nextFD++
 	}
 
-	// TODO(b/151157106): syscall tests fail by timeout if asyncpreemptoff
-	// isn't set.
-	if conf.Platform == "kvm" {
+	spillDir := conf.TmpfsSpillDir
+	if conf.OverlayUpper != "" {
+		// The sandbox can't open host files itself, so open the file that
+		// persists the root overlay's upper layer here. If a directory is
+		// given, use a file in it that is specific to this sandbox.
+		path := conf.OverlayUpper
+		if fi, err := os.Stat(path); err == nil && fi.IsDir() {
+			path = filepath.Join(path, s.ID+".upper")
+		}
+		upperFile, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0600)
+		if err != nil {
+			return fmt.Errorf("opening overlay upper file %q: %v", path, err)
+		}
+		defer upperFile.Close()
+		cmd.ExtraFiles = append(cmd.ExtraFiles, upperFile)
+		cmd.Args = append(cmd.Args, "--overlay-upper-fd="+strconv.Itoa(nextFD))
+		nextFD++
+
+		// The data of files in the upper layer is spilled to host storage,
+		// next to the upper file unless a spill directory is given.
+		if spillDir == "" {
+			spillDir = filepath.Dir(path)
+		}
+	}
+
+	if spillDir != "" {
+		// Spilled tmpfs data doesn't outlive the sandbox, so the file is
+		// unlinked once it's open.
+		spillFile, err := ioutil.TempFile(spillDir, "runsc-tmpfs-spill-")
+		if err != nil {
+			return fmt.Errorf("creating tmpfs spill file in %q: %v", spillDir, err)
+		}
+		defer spillFile.Close()
+		if err := os.Remove(spillFile.Name()); err != nil {
+			return fmt.Errorf("unlinking tmpfs spill file %q: %v", spillFile.Name(), err)
+		}
+		cmd.ExtraFiles = append(cmd.ExtraFiles, spillFile)
+		cmd.Args = append(cmd.Args, "--tmpfs-spill-fd="+strconv.Itoa(nextFD))
+		nextFD++
+	}
+
+	if conf.GoferReadCache != "" {
+		// The read cache is meant to outlive the sandbox, and is shared with
+		// other sandboxes that use the same file.
+		cacheFile, err := os.OpenFile(conf.GoferReadCache, os.O_RDWR|os.O_CREATE, 0600)
+		if err != nil {
+			return fmt.Errorf("opening gofer read cache %q: %v", conf.GoferReadCache, err)
+		}
+		defer cacheFile.Close()
+		fi, err := cacheFile.Stat()
+		if err != nil {
+			return fmt.Errorf("stat gofer read cache %q: %v", conf.GoferReadCache, err)
+		}
+		if size := int64(conf.GoferReadCacheSize); fi.Size() != size {
+			// Resizing the file discards its contents, since its layout
+			// depends on its size.
+			if err := cacheFile.Truncate(0); err != nil {
+				return fmt.Errorf("truncating gofer read cache %q: %v", conf.GoferReadCache, err)
+			}
+			if err := cacheFile.Truncate(size); err != nil {
+				return fmt.Errorf("resizing gofer read cache %q: %v", conf.GoferReadCache, err)
+			}
+		}
+		cmd.ExtraFiles = append(cmd.ExtraFiles, cacheFile)
+		cmd.Args = append(cmd.Args, "--gofer-read-cache-fd="+strconv.Itoa(nextFD))
+		nextFD++
+	}
+
+	if conf.AttestationDevice {
+		f, name, err := openAttestationDevice()
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		cmd.ExtraFiles = append(cmd.ExtraFiles, f)
+		cmd.Args = append(cmd.Args, "--attestation-fd="+strconv.Itoa(nextFD), "--attestation-device="+name)
+		nextFD++
+	}
+
+	// /dev/kfd may also be requested through CDI.
+	if conf.ROCm || specutils.HasDevice(args.Spec, "/dev/kfd") {
+		f, err := os.OpenFile("/dev/kfd", os.O_RDWR, 0)
+		if err != nil {
+			return fmt.Errorf("opening /dev/kfd: %v", err)
+		}
+		defer f.Close()
+		cmd.ExtraFiles = append(cmd.ExtraFiles, f)
+		cmd.Args = append(cmd.Args, "--kfd-fd="+strconv.Itoa(nextFD))
+		nextFD++
+	}
+
+	if conf.OTLPTraceFile != "" {
+		test := ""
+		if len(conf.TestOnlyTestNameEnv) != 0 {
+			// Fetch test name if one is provided and the test only flag was set.
+			if t, ok := specutils.EnvVar(args.Spec.Process.Env, conf.TestOnlyTestNameEnv); ok {
+				test = t
+			}
+		}
+
+		traceFile, err := specutils.DebugLogFile(conf.OTLPTraceFile, "boot", test)
+		if err != nil {
+			return fmt.Errorf("opening OTLP trace file in %q: %v", conf.OTLPTraceFile, err)
+		}
+		defer traceFile.Close()
+		cmd.ExtraFiles = append(cmd.ExtraFiles, traceFile)
+		cmd.Args = append(cmd.Args, "--otlp-trace-fd="+strconv.Itoa(nextFD))
+		nextFD++
+	}
+
+	if conf.ProfileRing != "" {
+		path := conf.ProfileRing
+		if fi, err := os.Stat(path); err == nil && fi.IsDir() {
+			path = filepath.Join(path, s.ID+".profiles")
+		}
+		ringFile, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0600)
+		if err != nil {
+			return fmt.Errorf("opening profile ring %q: %v", path, err)
+		}
+		defer ringFile.Close()
+		cmd.ExtraFiles = append(cmd.ExtraFiles, ringFile)
+		cmd.Args = append(cmd.Args, "--profile-ring-fd="+strconv.Itoa(nextFD))
+		nextFD++
+	}
+
+	if conf.WatchdogCheckpoint != "" {
+		path := conf.WatchdogCheckpoint
+		if fi, err := os.Stat(path); err == nil && fi.IsDir() {
+			path = filepath.Join(path, s.ID+".watchdog.state")
+		}
+		checkpointFile, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+		if err != nil {
+			return fmt.Errorf("opening watchdog checkpoint file %q: %v", path, err)
+		}
+		defer checkpointFile.Close()
+		cmd.ExtraFiles = append(cmd.ExtraFiles, checkpointFile)
+		cmd.Args = append(cmd.Args, "--watchdog-checkpoint-fd="+strconv.Itoa(nextFD))
+		nextFD++
+	}
+
+	if strings.HasPrefix(conf.CorePattern, "|") {
+		coreFile, err := startCoreCollector(conf.CorePattern[1:])
+		if err != nil {
+			return err
+		}
+		defer coreFile.Close()
+		cmd.ExtraFiles = append(cmd.ExtraFiles, coreFile)
+		cmd.Args = append(cmd.Args, "--core-dump-fd="+strconv.Itoa(nextFD))
+		nextFD++
+	}
+
+	if args.ExitFile != "" {
+		exitFile, err := os.OpenFile(args.ExitFile, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+		if err != nil {
+			return fmt.Errorf("opening exit file %q: %v", args.ExitFile, err)
+		}
+		defer exitFile.Close()
+		cmd.ExtraFiles = append(cmd.ExtraFiles, exitFile)
+		cmd.Args = append(cmd.Args, "--exit-status-fd="+strconv.Itoa(nextFD))
+		nextFD++
+	}
+
+	if gPlatform.Requirements().DisableAsyncPreemption {
 		cmd.Env = append(cmd.Env, "GODEBUG=asyncpreemptoff=1")
 	}
 
@@ -542,6 +858,12 @@ func (s *Sandbox) createSandboxProcess(conf *config.Config, args *Args, startSyn
 		}
 	}
 
+	for _, f := range args.ListenFiles {
+		cmd.ExtraFiles = append(cmd.ExtraFiles, f)
+		cmd.Args = append(cmd.Args, "--listen-fds="+strconv.Itoa(nextFD))
+		nextFD++
+	}
+
 	// Detach from this session, otherwise cmd will get SIGHUP and SIGCONT
 	// when re-parented.
 	cmd.SysProcAttr.Setsid = true
@@ -675,11 +997,6 @@ func (s *Sandbox) createSandboxProcess(conf *config.Config, args *Args, startSyn
 			return fmt.Errorf("getting cpu count from cgroups: %v", err)
 		}
 		if conf.CPUNumFromQuota {
-			// Dropping below 2 CPUs can trigger application to disable
-			// locks that can lead do hard to debug errors, so just
-			// leaving two cores as reasonable default.
-			const minCPUs = 2
-
 			quota, err := s.Cgroup.CPUQuota()
 			if err != nil {
 				return fmt.Errorf("getting cpu qouta from cgroups: %v", err)
@@ -706,6 +1023,9 @@ func (s *Sandbox) createSandboxProcess(conf *config.Config, args *Args, startSyn
 			cmd.Args = append(cmd.Args, "--total-memory", strconv.FormatUint(mem, 10))
 		}
 	}
+	if conf.MaxCPUs > 0 {
+		cmd.Args = append(cmd.Args, "--max-cpu-num", strconv.Itoa(conf.MaxCPUs))
+	}
 
 	if args.UserLog != "" {
 		f, err := os.OpenFile(args.UserLog, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0664)
@@ -804,6 +1124,9 @@ func (s *Sandbox) Wait(cid string) (syscall.WaitStatus, error) {
 		return syscall.WaitStatus(0), err
 	}
 	if !s.child {
+		if s.ExitFile != "" {
+			return readExitFile(s.ExitFile)
+		}
 		return syscall.WaitStatus(0), fmt.Errorf("sandbox no longer running and its exit status is unavailable")
 	}
 
@@ -812,6 +1135,24 @@ func (s *Sandbox) Wait(cid string) (syscall.WaitStatus, error) {
 	return s.status, nil
 }
 
+// readExitFile reads the exit status that the sandbox process wrote to path
+// when it exited.
+func readExitFile(path string) (syscall.WaitStatus, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return syscall.WaitStatus(0), fmt.Errorf("reading exit file: %v", err)
+	}
+	str := strings.TrimSpace(string(data))
+	if str == "" {
+		return syscall.WaitStatus(0), fmt.Errorf("sandbox no longer running and its exit status is unavailable")
+	}
+	status, err := strconv.ParseUint(str, 10, 32)
+	if err != nil {
+		return syscall.WaitStatus(0), fmt.Errorf("parsing exit file %q: %v", path, err)
+	}
+	return syscall.WaitStatus(status), nil
+}
+
 // WaitPID waits for process 'pid' in the container's sandbox and returns its
 // WaitStatus.
 func (s *Sandbox) WaitPID(cid string, pid int32) (syscall.WaitStatus, error) {
@@ -912,9 +1253,23 @@ func (s *Sandbox) SignalProcess(cid string, pid int32, sig syscall.Signal, fgPro
 	return nil
 }
 
+// CheckpointOpts contains options for Checkpoint.
+type CheckpointOpts struct {
+	// Key, if non-empty, is used to encrypt the statefile.
+	Key []byte
+
+	// Compression is the statefile compression setting. If empty,
+	// statefile.CompressionDefault is used.
+	Compression statefile.Compression
+
+	// Workers is the number of goroutines that compress the statefile, or
+	// zero for the default.
+	Workers int
+}
+
 // Checkpoint sends the checkpoint call for a container in the sandbox.
 // The statefile will be written to f.
-func (s *Sandbox) Checkpoint(cid string, f *os.File) error {
+func (s *Sandbox) Checkpoint(cid string, f *os.File, opts CheckpointOpts) error {
 	log.Debugf("Checkpoint sandbox %q", s.ID)
 	conn, err := s.sandboxConnect()
 	if err != nil {
@@ -926,6 +1281,9 @@ func (s *Sandbox) Checkpoint(cid string, f *os.File) error {
 		FilePayload: urpc.FilePayload{
 			Files: []*os.File{f},
 		},
+		Key:         opts.Key,
+		Compression: opts.Compression,
+		Workers:     opts.Workers,
 	}
 
 	if err := conn.Call(boot.ContainerCheckpoint, &opt, nil); err != nil {
@@ -934,6 +1292,30 @@ func (s *Sandbox) Checkpoint(cid string, f *os.File) error {
 	return nil
 }
 
+// Migrate sends the migrate call for a container in the sandbox, which writes
+// the sandbox's state to f while the sandbox runs. See
+// boot.containerManager.Migrate.
+func (s *Sandbox) Migrate(cid string, f *os.File, maxPause time.Duration, maxRounds int) error {
+	log.Debugf("Migrate sandbox %q", s.ID)
+	conn, err := s.sandboxConnect()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	opt := boot.MigrateOpts{
+		MaxPause:  maxPause,
+		MaxRounds: maxRounds,
+		FilePayload: urpc.FilePayload{
+			Files: []*os.File{f},
+		},
+	}
+	if err := conn.Call(boot.ContainerMigrate, &opt, nil); err != nil {
+		return fmt.Errorf("migrating container %q: %v", cid, err)
+	}
+	return nil
+}
+
 // Pause sends the pause call for a container in the sandbox.
 func (s *Sandbox) Pause(cid string) error {
 	log.Debugf("Pause sandbox %q", s.ID)
@@ -1008,6 +1390,22 @@ func (s *Sandbox) HeapProfile(f *os.File, delay time.Duration) error {
 	return conn.Call(boot.HeapProfile, &opts, nil)
 }
 
+// ProfileDump writes the profiles collected by continuous profiling to the
+// given file, as a tar archive.
+func (s *Sandbox) ProfileDump(f *os.File) error {
+	log.Debugf("Profile dump %q", s.ID)
+	conn, err := s.sandboxConnect()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	opts := control.RingDumpOpts{
+		FilePayload: urpc.FilePayload{Files: []*os.File{f}},
+	}
+	return conn.Call(boot.ProfileRingDump, &opts, nil)
+}
+
 // CPUProfile collects a CPU profile.
 func (s *Sandbox) CPUProfile(f *os.File, duration time.Duration) error {
 	log.Debugf("CPU profile %q", s.ID)
@@ -1072,6 +1470,23 @@ func (s *Sandbox) Trace(f *os.File, duration time.Duration) error {
 	return conn.Call(boot.Trace, &opts, nil)
 }
 
+// CapturePackets captures the packets traversing the network stack of the
+// sandbox to the given files, and returns the number of packets captured.
+func (s *Sandbox) CapturePackets(args *boot.CaptureArgs) (uint64, error) {
+	log.Debugf("Capture packets %q", s.ID)
+	conn, err := s.sandboxConnect()
+	if err != nil {
+		return 0, err
+	}
+	defer conn.Close()
+
+	var packets uint64
+	if err := conn.Call(boot.NetworkCapture, args, &packets); err != nil {
+		return 0, err
+	}
+	return packets, nil
+}
+
 // ChangeLogging changes logging options.
 func (s *Sandbox) ChangeLogging(args control.LoggingArgs) error {
 	log.Debugf("Change logging start %q", s.ID)
@@ -1162,13 +1577,58 @@ func deviceFileForPlatform(name string) (*os.File, error) {
 	return f, nil
 }
 
+// openAttestationDevice opens the attestation device of the confidential
+// computing guest that runsc runs in, returning the device and its name
+// relative to /dev.
+func openAttestationDevice() (*os.File, string, error) {
+	for _, name := range []string{"sev-guest", "tdx_guest"} {
+		f, err := os.OpenFile(filepath.Join("/dev", name), os.O_RDWR, 0)
+		if err == nil {
+			return f, name, nil
+		}
+		if !os.IsNotExist(err) {
+			return nil, "", fmt.Errorf("opening attestation device: %v", err)
+		}
+	}
+	return nil, "", fmt.Errorf("no attestation device found; runsc must run in an AMD SEV-SNP or Intel TDX guest")
+}
+
+// startCoreCollector starts the host command given by the --core-pattern flag,
+// with its stdin connected to the returned pipe, to which the sandbox writes
+// core dumps. The command reads EOF once the sandbox exits.
+func startCoreCollector(command string) (*os.File, error) {
+	args := strings.Fields(command)
+	r, w, err := os.Pipe()
+	if err != nil {
+		return nil, fmt.Errorf("creating core dump pipe: %v", err)
+	}
+	defer r.Close()
+
+	cmd := exec.Command(args[0], args[1:]...)
+	cmd.Stdin = r
+	// The collector outlives 'runsc create', so it must not be killed along
+	// with its process group.
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setsid: true}
+	if err := cmd.Start(); err != nil {
+		w.Close()
+		return nil, fmt.Errorf("starting core dump collector %q: %v", args[0], err)
+	}
+	log.Infof("Core dump collector %q started, PID: %d", args[0], cmd.Process.Pid)
+	// Reap the collector if it exits before this process does.
+	go cmd.Wait()
+	return w, nil
+}
+
 // checkBinaryPermissions verifies that the required binary bits are set on
 // the runsc executable.
 func checkBinaryPermissions(conf *config.Config) error {
 	// All platforms need the other exe bit
 	neededBits := os.FileMode(0001)
-	if conf.Platform == platforms.Ptrace {
-		// Ptrace needs the other read bit
+	p, err := platform.Lookup(conf.Platform)
+	if err != nil {
+		return err
+	}
+	if p.Requirements().RequiresReadableExecutable {
 		neededBits |= os.FileMode(0004)
 	}
 
@@ -0,0 +1,317 @@
+// Copyright 2020 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package specutils
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	specs "github.com/opencontainers/runtime-spec/specs-go"
+	"golang.org/x/sys/unix"
+	"gvisor.dev/gvisor/pkg/log"
+)
+
+// CDIAnnotationPrefix is the prefix of the annotations that request devices
+// described by Container Device Interface (CDI) specs. Their values are
+// comma-separated lists of fully qualified device names, e.g.
+// "vendor.com/class=name".
+const CDIAnnotationPrefix = "cdi.k8s.io/"
+
+// cdiSpec is a CDI spec, which describes the devices of a kind and how to
+// inject them into containers. See
+// https://github.com/container-orchestrated-devices/container-device-interface/blob/main/SPEC.md.
+type cdiSpec struct {
+	Version        string            `json:"cdiVersion"`
+	Kind           string            `json:"kind"`
+	Devices        []cdiDevice       `json:"devices"`
+	ContainerEdits cdiContainerEdits `json:"containerEdits,omitempty"`
+}
+
+// cdiDevice is a device described by a CDI spec.
+type cdiDevice struct {
+	Name           string            `json:"name"`
+	ContainerEdits cdiContainerEdits `json:"containerEdits"`
+}
+
+// cdiContainerEdits are the changes made to a container's spec to inject a
+// device.
+type cdiContainerEdits struct {
+	Env         []string        `json:"env,omitempty"`
+	DeviceNodes []cdiDeviceNode `json:"deviceNodes,omitempty"`
+	Hooks       []cdiHook       `json:"hooks,omitempty"`
+	Mounts      []cdiMount      `json:"mounts,omitempty"`
+}
+
+// cdiDeviceNode is a device node to create in the container.
+type cdiDeviceNode struct {
+	Path     string       `json:"path"`
+	HostPath string       `json:"hostPath,omitempty"`
+	Type     string       `json:"type,omitempty"`
+	Major    int64        `json:"major,omitempty"`
+	Minor    int64        `json:"minor,omitempty"`
+	FileMode *os.FileMode `json:"fileMode,omitempty"`
+	UID      *uint32      `json:"uid,omitempty"`
+	GID      *uint32      `json:"gid,omitempty"`
+}
+
+// cdiMount is a mount to add to the container.
+type cdiMount struct {
+	HostPath      string   `json:"hostPath"`
+	ContainerPath string   `json:"containerPath"`
+	Type          string   `json:"type,omitempty"`
+	Options       []string `json:"options,omitempty"`
+}
+
+// cdiHook is an OCI hook to add to the container.
+type cdiHook struct {
+	HookName string   `json:"hookName"`
+	Path     string   `json:"path"`
+	Args     []string `json:"args,omitempty"`
+	Env      []string `json:"env,omitempty"`
+	Timeout  *int     `json:"timeout,omitempty"`
+}
+
+// cdiRegistry holds the devices described by the CDI specs in a set of
+// directories.
+type cdiRegistry struct {
+	// devices maps fully qualified device names to their device.
+	devices map[string]*cdiDevice
+
+	// specs maps fully qualified device names to the spec that describes
+	// them.
+	specs map[string]*cdiSpec
+}
+
+// loadCDISpecs loads the CDI specs in dirs. Specs in later directories take
+// precedence over specs for the same devices in earlier ones. Only specs in
+// JSON format are supported.
+func loadCDISpecs(dirs []string) (*cdiRegistry, error) {
+	r := &cdiRegistry{
+		devices: make(map[string]*cdiDevice),
+		specs:   make(map[string]*cdiSpec),
+	}
+	for _, dir := range dirs {
+		paths, err := filepath.Glob(filepath.Join(dir, "*.json"))
+		if err != nil {
+			return nil, err
+		}
+		sort.Strings(paths)
+		for _, path := range paths {
+			data, err := ioutil.ReadFile(path)
+			if err != nil {
+				return nil, fmt.Errorf("reading CDI spec %q: %v", path, err)
+			}
+			spec := &cdiSpec{}
+			if err := json.Unmarshal(data, spec); err != nil {
+				return nil, fmt.Errorf("parsing CDI spec %q: %v", path, err)
+			}
+			if !strings.Contains(spec.Kind, "/") {
+				return nil, fmt.Errorf("CDI spec %q has invalid kind %q, want <vendor>/<class>", path, spec.Kind)
+			}
+			for i := range spec.Devices {
+				name := spec.Kind + "=" + spec.Devices[i].Name
+				r.devices[name] = &spec.Devices[i]
+				r.specs[name] = spec
+			}
+		}
+	}
+	return r, nil
+}
+
+// cdiDeviceNames returns the devices requested by the annotations of spec, in
+// a stable order.
+func cdiDeviceNames(spec *specs.Spec) []string {
+	var keys []string
+	for k := range spec.Annotations {
+		if strings.HasPrefix(k, CDIAnnotationPrefix) {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+
+	var names []string
+	seen := make(map[string]struct{})
+	for _, k := range keys {
+		for _, name := range strings.Split(spec.Annotations[k], ",") {
+			name = strings.TrimSpace(name)
+			if name == "" {
+				continue
+			}
+			if _, ok := seen[name]; ok {
+				continue
+			}
+			seen[name] = struct{}{}
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// ApplyCDIDevices injects the devices requested by the CDI annotations of
+// spec, as described by the CDI specs in dirs, into spec.
+func ApplyCDIDevices(spec *specs.Spec, dirs []string) error {
+	names := cdiDeviceNames(spec)
+	if len(names) == 0 {
+		return nil
+	}
+	r, err := loadCDISpecs(dirs)
+	if err != nil {
+		return err
+	}
+	applied := make(map[*cdiSpec]struct{})
+	for _, name := range names {
+		dev, ok := r.devices[name]
+		if !ok {
+			return fmt.Errorf("CDI device %q not found in %s", name, strings.Join(dirs, ", "))
+		}
+		// Edits of the spec apply once, if any of its devices is requested.
+		if s := r.specs[name]; s != nil {
+			if _, ok := applied[s]; !ok {
+				applied[s] = struct{}{}
+				if err := applyCDIEdits(spec, &s.ContainerEdits); err != nil {
+					return fmt.Errorf("injecting CDI device %q: %v", name, err)
+				}
+			}
+		}
+		if err := applyCDIEdits(spec, &dev.ContainerEdits); err != nil {
+			return fmt.Errorf("injecting CDI device %q: %v", name, err)
+		}
+		log.Infof("Injected CDI device %q", name)
+	}
+	return nil
+}
+
+// applyCDIEdits applies edits to spec.
+func applyCDIEdits(spec *specs.Spec, edits *cdiContainerEdits) error {
+	if len(edits.Env) > 0 {
+		if spec.Process == nil {
+			spec.Process = &specs.Process{}
+		}
+		spec.Process.Env = append(spec.Process.Env, edits.Env...)
+	}
+
+	for _, n := range edits.DeviceNodes {
+		dev, err := cdiLinuxDevice(&n)
+		if err != nil {
+			return err
+		}
+		if spec.Linux == nil {
+			spec.Linux = &specs.Linux{}
+		}
+		spec.Linux.Devices = append(spec.Linux.Devices, dev)
+	}
+
+	for _, m := range edits.Mounts {
+		typ := m.Type
+		if typ == "" {
+			typ = "bind"
+		}
+		spec.Mounts = append(spec.Mounts, specs.Mount{
+			Destination: m.ContainerPath,
+			Source:      m.HostPath,
+			Type:        typ,
+			Options:     m.Options,
+		})
+	}
+
+	for _, h := range edits.Hooks {
+		if spec.Hooks == nil {
+			spec.Hooks = &specs.Hooks{}
+		}
+		hook := specs.Hook{
+			Path:    h.Path,
+			Args:    h.Args,
+			Env:     h.Env,
+			Timeout: h.Timeout,
+		}
+		switch h.HookName {
+		case "prestart":
+			spec.Hooks.Prestart = append(spec.Hooks.Prestart, hook)
+		case "createRuntime":
+			spec.Hooks.CreateRuntime = append(spec.Hooks.CreateRuntime, hook)
+		case "createContainer":
+			spec.Hooks.CreateContainer = append(spec.Hooks.CreateContainer, hook)
+		case "startContainer":
+			spec.Hooks.StartContainer = append(spec.Hooks.StartContainer, hook)
+		case "poststart":
+			spec.Hooks.Poststart = append(spec.Hooks.Poststart, hook)
+		case "poststop":
+			spec.Hooks.Poststop = append(spec.Hooks.Poststop, hook)
+		default:
+			return fmt.Errorf("invalid hook name %q", h.HookName)
+		}
+	}
+	return nil
+}
+
+// cdiLinuxDevice returns the OCI device for n. The type and numbers of the
+// device are taken from the host device if n doesn't specify them.
+func cdiLinuxDevice(n *cdiDeviceNode) (specs.LinuxDevice, error) {
+	dev := specs.LinuxDevice{
+		Path:     n.Path,
+		Type:     n.Type,
+		Major:    n.Major,
+		Minor:    n.Minor,
+		FileMode: n.FileMode,
+		UID:      n.UID,
+		GID:      n.GID,
+	}
+	if dev.Type != "" && (dev.Major != 0 || dev.Type == "p") {
+		return dev, nil
+	}
+	hostPath := n.HostPath
+	if hostPath == "" {
+		hostPath = n.Path
+	}
+	var st unix.Stat_t
+	if err := unix.Stat(hostPath, &st); err != nil {
+		return dev, fmt.Errorf("stat device %q: %v", hostPath, err)
+	}
+	switch st.Mode & unix.S_IFMT {
+	case unix.S_IFCHR:
+		dev.Type = "c"
+	case unix.S_IFBLK:
+		dev.Type = "b"
+	case unix.S_IFIFO:
+		dev.Type = "p"
+	default:
+		return dev, fmt.Errorf("%q isn't a device", hostPath)
+	}
+	dev.Major = int64(unix.Major(st.Rdev))
+	dev.Minor = int64(unix.Minor(st.Rdev))
+	if dev.FileMode == nil {
+		mode := os.FileMode(st.Mode &^ unix.S_IFMT)
+		dev.FileMode = &mode
+	}
+	return dev, nil
+}
+
+// HasDevice returns true if spec has a device node at path.
+func HasDevice(spec *specs.Spec, path string) bool {
+	if spec.Linux == nil {
+		return false
+	}
+	for _, dev := range spec.Linux.Devices {
+		if dev.Path == path {
+			return true
+		}
+	}
+	return false
+}
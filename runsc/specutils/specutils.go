@@ -35,6 +35,7 @@ import (
 	"gvisor.dev/gvisor/pkg/abi/linux"
 	"gvisor.dev/gvisor/pkg/bits"
 	"gvisor.dev/gvisor/pkg/log"
+	"gvisor.dev/gvisor/pkg/otlp"
 	"gvisor.dev/gvisor/pkg/sentry/kernel/auth"
 	"gvisor.dev/gvisor/runsc/config"
 )
@@ -196,9 +197,8 @@ func ReadSpecFromFile(bundleDir string, specFile *os.File, conf *config.Config)
 	// Override flags using annotation to allow customization per sandbox
 	// instance.
 	for annotation, val := range spec.Annotations {
-		const flagPrefix = "dev.gvisor.flag."
-		if strings.HasPrefix(annotation, flagPrefix) {
-			name := annotation[len(flagPrefix):]
+		if strings.HasPrefix(annotation, FlagAnnotationPrefix) {
+			name := annotation[len(FlagAnnotationPrefix):]
 			log.Infof("Overriding flag: %s=%q", name, val)
 			if err := conf.Override(name, val); err != nil {
 				return nil, err
@@ -206,6 +206,12 @@ func ReadSpecFromFile(bundleDir string, specFile *os.File, conf *config.Config)
 		}
 	}
 
+	if conf.CDISpecDirs != "" {
+		if err := ApplyCDIDevices(&spec, strings.Split(conf.CDISpecDirs, ",")); err != nil {
+			return nil, err
+		}
+	}
+
 	return &spec, nil
 }
 
@@ -337,6 +343,123 @@ func Is9PMount(m specs.Mount) bool {
 	return m.Type == "bind" && m.Source != "" && IsSupportedDevMount(m)
 }
 
+// IsVirtioFSMount returns true if the given mount is a virtiofs filesystem
+// served over the vhost-user socket at its source.
+func IsVirtioFSMount(m specs.Mount) bool {
+	return m.Type == "virtiofs" && m.Source != "" && IsSupportedDevMount(m)
+}
+
+// IsEROFSMount returns true if the given mount is an EROFS filesystem read
+// from the image file at its source.
+func IsEROFSMount(m specs.Mount) bool {
+	return m.Type == "erofs" && m.Source != "" && IsSupportedDevMount(m)
+}
+
+// IsSquashFSMount returns true if the given mount is a SquashFS filesystem
+// read from the image file at its source.
+func IsSquashFSMount(m specs.Mount) bool {
+	return m.Type == "squashfs" && m.Source != "" && IsSupportedDevMount(m)
+}
+
+// IsImageMount returns true if the given mount is read by the sandbox from
+// the image file at its source.
+func IsImageMount(m specs.Mount) bool {
+	return IsEROFSMount(m) || IsSquashFSMount(m)
+}
+
+const (
+	// RootfsTypeAnnotation is the annotation that specifies the type of the
+	// container's root filesystem. "erofs", "squashfs" and "estargz" are
+	// supported; by default, the root directory in the spec is served by the
+	// gofer.
+	RootfsTypeAnnotation = "dev.gvisor.spec.rootfs.type"
+
+	// RootfsSourceAnnotation is the annotation that specifies the image of
+	// the container's root filesystem if RootfsTypeAnnotation is set. For
+	// "estargz", it is the reference of an image in a registry, whose files
+	// the gofer fetches as they are used.
+	RootfsSourceAnnotation = "dev.gvisor.spec.rootfs.source"
+
+	// RootfsUIDMapAnnotation and RootfsGIDMapAnnotation are the annotations
+	// that make the container's root filesystem an ID-mapped mount. Their
+	// values have the same format as the uidmap and gidmap mount options.
+	RootfsUIDMapAnnotation = "dev.gvisor.spec.rootfs.uidmap"
+	RootfsGIDMapAnnotation = "dev.gvisor.spec.rootfs.gidmap"
+)
+
+// RootfsIDMapOptions returns the mount options that make the container's
+// root filesystem an ID-mapped mount, if the spec requests one.
+func RootfsIDMapOptions(spec *specs.Spec) []string {
+	var opts []string
+	if uidMap, ok := spec.Annotations[RootfsUIDMapAnnotation]; ok {
+		opts = append(opts, "uidmap="+uidMap)
+	}
+	if gidMap, ok := spec.Annotations[RootfsGIDMapAnnotation]; ok {
+		opts = append(opts, "gidmap="+gidMap)
+	}
+	return opts
+}
+
+// RootfsImage returns the filesystem type and path of the image to be mounted
+// as the container's root filesystem, if the spec requests one.
+func RootfsImage(spec *specs.Spec) (string, string, bool) {
+	fsType := spec.Annotations[RootfsTypeAnnotation]
+	if fsType != "erofs" && fsType != "squashfs" {
+		return "", "", false
+	}
+	source, ok := spec.Annotations[RootfsSourceAnnotation]
+	return fsType, source, ok && source != ""
+}
+
+// RootfsLazyImage returns the reference of the image whose files the gofer
+// serves as the container's root filesystem, if the spec requests one.
+func RootfsLazyImage(spec *specs.Spec) (string, bool) {
+	if spec.Annotations[RootfsTypeAnnotation] != "estargz" {
+		return "", false
+	}
+	ref, ok := spec.Annotations[RootfsSourceAnnotation]
+	return ref, ok && ref != ""
+}
+
+// FlagAnnotationPrefix is the prefix of the annotations that override flags,
+// as dev.gvisor.flag.<name>. See config.Config.Override.
+const FlagAnnotationPrefix = "dev.gvisor.flag."
+
+// TraceParentAnnotation is the annotation that holds the W3C Trace Context
+// traceparent of the operation that created the container. Spans exported by
+// runsc and the sandbox for the container are its children.
+const TraceParentAnnotation = "dev.gvisor.traceparent"
+
+// TraceParent returns the parent of the container's spans. If the spec has no
+// valid TraceParentAnnotation, the spans are roots of a trace whose ID is
+// derived from sandboxID, so that all spans of the sandbox are correlated.
+func TraceParent(spec *specs.Spec, sandboxID string) otlp.SpanContext {
+	if tp, ok := spec.Annotations[TraceParentAnnotation]; ok {
+		sc, err := otlp.ParseTraceParent(tp)
+		if err == nil {
+			return sc
+		}
+		log.Warningf("Ignoring annotation %q: %v", TraceParentAnnotation, err)
+	}
+	return otlp.SpanContext{TraceID: otlp.DeriveTraceID(sandboxID)}
+}
+
+// IsNFSMount returns true if the given mount is an NFSv4 filesystem whose
+// source has the form "server:/export".
+func IsNFSMount(m specs.Mount) bool {
+	return m.Type == "nfs4" && strings.Contains(m.Source, ":/") && IsSupportedDevMount(m)
+}
+
+// SplitNFSSource splits the source of an NFS mount into the server address and
+// the path of the exported directory on the server.
+func SplitNFSSource(source string) (server, export string) {
+	i := strings.LastIndex(source, ":/")
+	if i < 0 {
+		return source, "/"
+	}
+	return source[:i], source[i+1:]
+}
+
 // IsSupportedDevMount returns true if the mount is a supported /dev mount.
 // Only mount that does not conflict with runsc default /dev mount is
 // supported.
@@ -16,7 +16,11 @@ package specutils
 
 import (
 	"fmt"
+	"io/ioutil"
+	"os"
 	"os/exec"
+	"path/filepath"
+	"reflect"
 	"strings"
 	"testing"
 	"time"
@@ -263,3 +267,65 @@ func TestSpecInvalid(t *testing.T) {
 		}
 	}
 }
+
+func TestApplyCDIDevices(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cdi")
+	if err != nil {
+		t.Fatalf("TempDir failed: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	const cdiSpec = `{
+  "cdiVersion": "0.5.0",
+  "kind": "vendor.com/gpu",
+  "containerEdits": {"env": ["VENDOR_DRIVER=1"]},
+  "devices": [
+    {
+      "name": "gpu0",
+      "containerEdits": {
+        "env": ["GPU=0"],
+        "deviceNodes": [{"path": "/dev/gpu0", "type": "c", "major": 200, "minor": 0}],
+        "mounts": [{"hostPath": "/usr/lib/libgpu.so", "containerPath": "/usr/lib/libgpu.so", "options": ["ro"]}],
+        "hooks": [{"hookName": "poststop", "path": "/bin/true"}]
+      }
+    },
+    {
+      "name": "gpu1",
+      "containerEdits": {
+        "env": ["GPU=1"],
+        "deviceNodes": [{"path": "/dev/gpu1", "type": "c", "major": 200, "minor": 1}]
+      }
+    }
+  ]
+}`
+	if err := ioutil.WriteFile(filepath.Join(dir, "gpu.json"), []byte(cdiSpec), 0644); err != nil {
+		t.Fatalf("WriteFile failed: %v", err)
+	}
+
+	spec := &specs.Spec{
+		Process: &specs.Process{},
+		Annotations: map[string]string{
+			CDIAnnotationPrefix + "gpu": "vendor.com/gpu=gpu0, vendor.com/gpu=gpu1",
+		},
+	}
+	if err := ApplyCDIDevices(spec, []string{dir}); err != nil {
+		t.Fatalf("ApplyCDIDevices failed: %v", err)
+	}
+	if want := []string{"VENDOR_DRIVER=1", "GPU=0", "GPU=1"}; !reflect.DeepEqual(spec.Process.Env, want) {
+		t.Errorf("got env %v, want %v", spec.Process.Env, want)
+	}
+	if !HasDevice(spec, "/dev/gpu0") || !HasDevice(spec, "/dev/gpu1") {
+		t.Errorf("got devices %+v, want /dev/gpu0 and /dev/gpu1", spec.Linux.Devices)
+	}
+	if len(spec.Mounts) != 1 || spec.Mounts[0].Type != "bind" || spec.Mounts[0].Destination != "/usr/lib/libgpu.so" {
+		t.Errorf("got mounts %+v, want a bind mount of /usr/lib/libgpu.so", spec.Mounts)
+	}
+	if spec.Hooks == nil || len(spec.Hooks.Poststop) != 1 {
+		t.Errorf("got hooks %+v, want one poststop hook", spec.Hooks)
+	}
+
+	spec.Annotations[CDIAnnotationPrefix+"gpu"] = "vendor.com/gpu=gpu2"
+	if err := ApplyCDIDevices(spec, []string{dir}); err == nil {
+		t.Errorf("ApplyCDIDevices of an unknown device succeeded")
+	}
+}
@@ -0,0 +1,78 @@
+// Copyright 2021 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package specutils
+
+import (
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	specs "github.com/opencontainers/runtime-spec/specs-go"
+)
+
+const (
+	// NotifySocketDir is the directory in the container where the systemd
+	// notification socket is mounted.
+	NotifySocketDir = "/run/notify"
+
+	// NotifySocketName is the name of the systemd notification socket in
+	// NotifySocketDir.
+	NotifySocketName = "notify.sock"
+
+	// NotifySocketDirPrefix is the prefix of the names of the host
+	// directories, in the runsc root directory, that hold notification
+	// sockets.
+	NotifySocketDirPrefix = "notify-"
+)
+
+// AddListenFDs sets the environment variables that tell the container's init
+// process about the n files passed to it by socket activation, as described in
+// sd_listen_fds(3). names may be nil.
+func AddListenFDs(spec *specs.Spec, n int, names []string) {
+	if n == 0 {
+		return
+	}
+	// The container's init process is always PID 1 in the sandbox.
+	spec.Process.Env = append(spec.Process.Env,
+		"LISTEN_FDS="+strconv.Itoa(n),
+		"LISTEN_PID=1")
+	if names != nil {
+		spec.Process.Env = append(spec.Process.Env, "LISTEN_FDNAMES="+strings.Join(names, ":"))
+	}
+}
+
+// AddNotifySocket mounts the notification socket in hostDir into the
+// container, and points the container's init process at it, as described in
+// sd_notify(3). watchdogUSec may be empty if the watchdog isn't enabled.
+func AddNotifySocket(spec *specs.Spec, hostDir, watchdogUSec string) {
+	spec.Mounts = append(spec.Mounts, specs.Mount{
+		Destination: NotifySocketDir,
+		Source:      hostDir,
+		Type:        "bind",
+		Options:     []string{"bind", "nosuid", "nodev", "noexec"},
+	})
+	spec.Process.Env = append(spec.Process.Env, "NOTIFY_SOCKET="+filepath.Join(NotifySocketDir, NotifySocketName))
+	if watchdogUSec != "" {
+		spec.Process.Env = append(spec.Process.Env, "WATCHDOG_USEC="+watchdogUSec)
+	}
+}
+
+// IsNotifySocketMount returns true if m is the mount added by AddNotifySocket
+// for a directory in rootDir. Such mounts are identified by their source, which
+// can't be forged by the container's annotations.
+func IsNotifySocketMount(rootDir string, m specs.Mount) bool {
+	return filepath.Dir(m.Source) == filepath.Clean(rootDir) &&
+		strings.HasPrefix(filepath.Base(m.Source), NotifySocketDirPrefix)
+}
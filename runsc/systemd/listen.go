@@ -0,0 +1,85 @@
+// Copyright 2021 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package systemd implements the parts of the systemd service protocols,
+// socket activation and readiness notification, that runsc passes through to
+// containers.
+package systemd
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"golang.org/x/sys/unix"
+)
+
+// listenFDsStart is the first file descriptor passed by socket activation.
+const listenFDsStart = 3
+
+// ListenFiles returns the files passed to this process by socket activation,
+// as described in sd_listen_fds(3), and their names. Names are nil if
+// LISTEN_FDNAMES isn't set. The returned files are close-on-exec, and the
+// environment variables describing them are unset, so that they aren't
+// inherited by other processes.
+func ListenFiles() ([]*os.File, []string, error) {
+	n, names, err := parseListenEnv(os.Getenv("LISTEN_PID"), os.Getenv("LISTEN_FDS"), os.Getenv("LISTEN_FDNAMES"), os.Getpid())
+	os.Unsetenv("LISTEN_PID")
+	os.Unsetenv("LISTEN_FDS")
+	os.Unsetenv("LISTEN_FDNAMES")
+	if err != nil || n == 0 {
+		return nil, nil, err
+	}
+
+	files := make([]*os.File, 0, n)
+	for fd := listenFDsStart; fd < listenFDsStart+n; fd++ {
+		unix.CloseOnExec(fd)
+		name := "listen-fd-" + strconv.Itoa(fd)
+		if names != nil {
+			name = names[fd-listenFDsStart]
+		}
+		files = append(files, os.NewFile(uintptr(fd), name))
+	}
+	return files, names, nil
+}
+
+// parseListenEnv parses the values of LISTEN_PID, LISTEN_FDS and
+// LISTEN_FDNAMES, and returns the number of files passed to the process with
+// PID self, and their names.
+func parseListenEnv(pidEnv, fdsEnv, namesEnv string, self int) (int, []string, error) {
+	if pidEnv == "" {
+		return 0, nil, nil
+	}
+	pid, err := strconv.Atoi(pidEnv)
+	if err != nil {
+		return 0, nil, fmt.Errorf("invalid LISTEN_PID %q: %w", pidEnv, err)
+	}
+	if pid != self {
+		// The files were meant for another process.
+		return 0, nil, nil
+	}
+	n, err := strconv.Atoi(fdsEnv)
+	if err != nil || n < 0 {
+		return 0, nil, fmt.Errorf("invalid LISTEN_FDS %q", fdsEnv)
+	}
+	if namesEnv == "" {
+		return n, nil, nil
+	}
+	names := strings.Split(namesEnv, ":")
+	if len(names) != n {
+		return 0, nil, fmt.Errorf("LISTEN_FDNAMES %q has %d names, want %d", namesEnv, len(names), n)
+	}
+	return n, names, nil
+}
@@ -0,0 +1,143 @@
+// Copyright 2021 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package systemd
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"gvisor.dev/gvisor/pkg/log"
+	"gvisor.dev/gvisor/runsc/specutils"
+)
+
+// maxNotificationSize is the largest notification forwarded. systemd itself
+// doesn't accept notifications larger than a page.
+const maxNotificationSize = 4096
+
+// NotifyProxy forwards the notifications sent by a container, as described in
+// sd_notify(3), to the notification socket of the service manager.
+//
+// Notifications are sent by this process on behalf of the container, so that
+// they're accepted by services with NotifyAccess=main.
+type NotifyProxy struct {
+	dir    string
+	target string
+	conn   *net.UnixConn
+}
+
+// NewNotifyProxy creates a notification socket in a new directory in rootDir,
+// that forwards notifications to the socket at target. The directory is meant
+// to be mounted into the container with specutils.AddNotifySocket.
+func NewNotifyProxy(rootDir, target string) (*NotifyProxy, error) {
+	dir, err := ioutil.TempDir(rootDir, specutils.NotifySocketDirPrefix)
+	if err != nil {
+		return nil, fmt.Errorf("creating notification socket directory: %w", err)
+	}
+	// The container may run as any user.
+	if err := os.Chmod(dir, 0711); err != nil {
+		os.RemoveAll(dir)
+		return nil, err
+	}
+	path := filepath.Join(dir, specutils.NotifySocketName)
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: path, Net: "unixgram"})
+	if err != nil {
+		os.RemoveAll(dir)
+		return nil, fmt.Errorf("creating notification socket: %w", err)
+	}
+	if err := os.Chmod(path, 0666); err != nil {
+		conn.Close()
+		os.RemoveAll(dir)
+		return nil, err
+	}
+	return &NotifyProxy{dir: dir, target: target, conn: conn}, nil
+}
+
+// Dir returns the directory holding the notification socket.
+func (p *NotifyProxy) Dir() string {
+	return p.dir
+}
+
+// Serve forwards notifications until the proxy is closed.
+func (p *NotifyProxy) Serve() {
+	buf := make([]byte, maxNotificationSize)
+	for {
+		n, _, err := p.conn.ReadFromUnix(buf)
+		if err != nil {
+			// The proxy was closed.
+			return
+		}
+		msg := filterNotification(string(buf[:n]))
+		if msg == "" {
+			continue
+		}
+		if err := p.forward(msg); err != nil {
+			log.Warningf("Forwarding notification %q to %q: %v", msg, p.target, err)
+		}
+	}
+}
+
+func (p *NotifyProxy) forward(msg string) error {
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: p.target, Net: "unixgram"})
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	_, err = conn.Write([]byte(msg))
+	return err
+}
+
+// Close stops the proxy and removes its directory.
+func (p *NotifyProxy) Close() error {
+	err := p.conn.Close()
+	if rmErr := os.RemoveAll(p.dir); err == nil {
+		err = rmErr
+	}
+	return err
+}
+
+// filterNotification removes the assignments of msg that refer to the
+// container's processes or files, which don't make sense to the service
+// manager, and returns the rest.
+func filterNotification(msg string) string {
+	var kept []string
+	for _, line := range strings.Split(msg, "\n") {
+		name := strings.SplitN(line, "=", 2)[0]
+		switch name {
+		case "", "MAINPID", "FDSTORE", "FDSTOREREMOVE", "FDNAME", "FDPOLL", "BARRIER", "NOTIFYACCESS":
+			continue
+		}
+		kept = append(kept, line)
+	}
+	return strings.Join(kept, "\n")
+}
+
+// WatchdogUSec returns the watchdog timeout in microseconds set for this
+// process by the service manager, as described in sd_watchdog_enabled(3), or
+// an empty string if the watchdog isn't enabled.
+func WatchdogUSec() string {
+	usec := os.Getenv("WATCHDOG_USEC")
+	if usec == "" {
+		return ""
+	}
+	if pid := os.Getenv("WATCHDOG_PID"); pid != "" && pid != strconv.Itoa(os.Getpid()) {
+		return ""
+	}
+	return usec
+}
@@ -0,0 +1,112 @@
+// Copyright 2021 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package systemd
+
+import (
+	"net"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	specs "github.com/opencontainers/runtime-spec/specs-go"
+	"gvisor.dev/gvisor/runsc/specutils"
+)
+
+func TestParseListenEnv(t *testing.T) {
+	for _, tc := range []struct {
+		name      string
+		pid       string
+		fds       string
+		names     string
+		wantN     int
+		wantNames []string
+		wantErr   bool
+	}{
+		{name: "unset"},
+		{name: "other process", pid: "2", fds: "1"},
+		{name: "no names", pid: "1", fds: "2", wantN: 2},
+		{name: "names", pid: "1", fds: "2", names: "http:https", wantN: 2, wantNames: []string{"http", "https"}},
+		{name: "invalid pid", pid: "x", fds: "1", wantErr: true},
+		{name: "invalid fds", pid: "1", fds: "-1", wantErr: true},
+		{name: "names mismatch", pid: "1", fds: "2", names: "http", wantErr: true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			n, names, err := parseListenEnv(tc.pid, tc.fds, tc.names, 1)
+			if gotErr := err != nil; gotErr != tc.wantErr {
+				t.Fatalf("parseListenEnv got error %v, want error: %t", err, tc.wantErr)
+			}
+			if n != tc.wantN || !reflect.DeepEqual(names, tc.wantNames) {
+				t.Errorf("parseListenEnv got (%d, %q), want (%d, %q)", n, names, tc.wantN, tc.wantNames)
+			}
+		})
+	}
+}
+
+func TestFilterNotification(t *testing.T) {
+	for _, tc := range []struct {
+		msg  string
+		want string
+	}{
+		{msg: "READY=1", want: "READY=1"},
+		{msg: "READY=1\nSTATUS=Serving\n", want: "READY=1\nSTATUS=Serving"},
+		{msg: "MAINPID=42\nREADY=1", want: "READY=1"},
+		{msg: "FDSTORE=1\nFDNAME=conn", want: ""},
+		{msg: "WATCHDOG=1", want: "WATCHDOG=1"},
+	} {
+		if got := filterNotification(tc.msg); got != tc.want {
+			t.Errorf("filterNotification(%q) = %q, want %q", tc.msg, got, tc.want)
+		}
+	}
+}
+
+func TestNotifyProxy(t *testing.T) {
+	dir := t.TempDir()
+	target := filepath.Join(dir, "target.sock")
+	targetConn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: target, Net: "unixgram"})
+	if err != nil {
+		t.Fatalf("ListenUnixgram failed: %v", err)
+	}
+	defer targetConn.Close()
+
+	p, err := NewNotifyProxy(dir, target)
+	if err != nil {
+		t.Fatalf("NewNotifyProxy failed: %v", err)
+	}
+	defer p.Close()
+	if !specutils.IsNotifySocketMount(dir, specs.Mount{Source: p.Dir()}) {
+		t.Errorf("IsNotifySocketMount(%q) = false, want true", p.Dir())
+	}
+	go p.Serve()
+
+	conn, err := net.Dial("unixgram", filepath.Join(p.Dir(), specutils.NotifySocketName))
+	if err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+	defer conn.Close()
+	if _, err := conn.Write([]byte("MAINPID=42\nREADY=1")); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+
+	buf := make([]byte, maxNotificationSize)
+	targetConn.SetReadDeadline(time.Now().Add(10 * time.Second))
+	n, err := targetConn.Read(buf)
+	if err != nil {
+		t.Fatalf("Read failed: %v", err)
+	}
+	if got, want := string(buf[:n]), "READY=1"; got != want {
+		t.Errorf("forwarded notification: got %q, want %q", got, want)
+	}
+}
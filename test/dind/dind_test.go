@@ -0,0 +1,57 @@
+// Copyright 2021 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package dind runs dockerd inside a sandbox, and uses it to run and build
+// containers. The runtime must be configured with the Docker-in-gVisor
+// profile: --vfs2 --overlay --net-raw --cgroupfs.
+package dind
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"gvisor.dev/gvisor/pkg/test/dockerutil"
+)
+
+// runDockerInGVisor starts dockerd in a sandbox and runs the given mode of the
+// test script of the basic/docker image.
+func runDockerInGVisor(t *testing.T, mode string) {
+	ctx := context.Background()
+	d := dockerutil.MakeContainer(ctx, t)
+	defer d.CleanUp(ctx)
+
+	out, err := d.Run(ctx, dockerutil.RunOpts{
+		Image:      "basic/docker",
+		Privileged: true,
+	}, mode)
+	if err != nil {
+		t.Fatalf("docker run failed: %v\noutput: %s", err, out)
+	}
+	if !strings.Contains(out, "nested container OK") {
+		t.Fatalf("nested container didn't run, output: %s", out)
+	}
+}
+
+func TestDockerRun(t *testing.T) {
+	runDockerInGVisor(t, "run")
+}
+
+func TestDockerBuild(t *testing.T) {
+	runDockerInGVisor(t, "build")
+}
+
+func TestDockerBuildKit(t *testing.T) {
+	runDockerInGVisor(t, "buildkit")
+}
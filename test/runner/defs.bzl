@@ -62,6 +62,7 @@ def _syscall_test(
         add_uds_tree = False,
         vfs2 = False,
         fuse = False,
+        core_pattern = "",
         **kwargs):
     # Prepend "runsc" to non-native platform names.
     full_platform = platform if platform == "native" else "runsc_" + platform
@@ -116,6 +117,7 @@ def _syscall_test(
         "--add-uds-tree=" + str(add_uds_tree),
         "--vfs2=" + str(vfs2),
         "--fuse=" + str(fuse),
+        "--core-pattern=" + core_pattern,
         "--strace=" + str(debug),
         "--debug=" + str(debug),
     ]
@@ -138,6 +140,7 @@ def syscall_test(
         vfs2 = True,
         fuse = False,
         debug = True,
+        core_pattern = "",
         tags = None,
         **kwargs):
     """syscall_test is a macro that will create targets for all platforms.
@@ -151,6 +154,8 @@ def syscall_test(
       vfs2: enable VFS2 support.
       fuse: enable FUSE support.
       debug: enable debug output.
+      core_pattern: the --core-pattern of the VFS2 test, which is the only
+        one that supports core dump files.
       tags: starting test tags.
       **kwargs: additional test arguments.
     """
@@ -179,6 +184,7 @@ def syscall_test(
         debug = debug,
         vfs2 = True,
         fuse = fuse,
+        core_pattern = core_pattern,
         **kwargs
     )
     if fuse:
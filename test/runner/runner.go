@@ -40,16 +40,17 @@ import (
 )
 
 var (
-	debug      = flag.Bool("debug", false, "enable debug logs")
-	strace     = flag.Bool("strace", false, "enable strace logs")
-	platform   = flag.String("platform", "ptrace", "platform to run on")
-	network    = flag.String("network", "none", "network stack to run on (sandbox, host, none)")
-	useTmpfs   = flag.Bool("use-tmpfs", false, "mounts tmpfs for /tmp")
-	fileAccess = flag.String("file-access", "exclusive", "mounts root in exclusive or shared mode")
-	overlay    = flag.Bool("overlay", false, "wrap filesystem mounts with writable tmpfs overlay")
-	vfs2       = flag.Bool("vfs2", false, "enable VFS2")
-	fuse       = flag.Bool("fuse", false, "enable FUSE")
-	runscPath  = flag.String("runsc", "", "path to runsc binary")
+	debug       = flag.Bool("debug", false, "enable debug logs")
+	strace      = flag.Bool("strace", false, "enable strace logs")
+	platform    = flag.String("platform", "ptrace", "platform to run on")
+	network     = flag.String("network", "none", "network stack to run on (sandbox, host, none)")
+	useTmpfs    = flag.Bool("use-tmpfs", false, "mounts tmpfs for /tmp")
+	fileAccess  = flag.String("file-access", "exclusive", "mounts root in exclusive or shared mode")
+	overlay     = flag.Bool("overlay", false, "wrap filesystem mounts with writable tmpfs overlay")
+	vfs2        = flag.Bool("vfs2", false, "enable VFS2")
+	fuse        = flag.Bool("fuse", false, "enable FUSE")
+	corePattern = flag.String("core-pattern", "", "pattern of core dump files, if any")
+	runscPath   = flag.String("runsc", "", "path to runsc binary")
 
 	addUDSTree = flag.Bool("add-uds-tree", false, "expose a tree of UDS utilities for use in tests")
 	// TODO(gvisor.dev/issue/4572): properly support leak checking for runsc, and
@@ -162,6 +163,9 @@ func runRunsc(tc gtest.TestCase, spec *specs.Spec) error {
 			args = append(args, "-fuse")
 		}
 	}
+	if *corePattern != "" {
+		args = append(args, "-core-pattern", *corePattern)
+	}
 	if *debug {
 		args = append(args, "-debug", "-log-packets=true")
 	}
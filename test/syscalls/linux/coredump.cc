@@ -0,0 +1,191 @@
+// Copyright 2021 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+#include <elf.h>
+#include <signal.h>
+#include <sys/prctl.h>
+#include <sys/procfs.h>
+#include <sys/resource.h>
+#include <sys/types.h>
+#include <sys/wait.h>
+#include <unistd.h>
+
+#include <cstring>
+#include <string>
+#include <vector>
+
+#include "gmock/gmock.h"
+#include "gtest/gtest.h"
+#include "absl/strings/match.h"
+#include "absl/strings/str_cat.h"
+#include "test/util/fs_util.h"
+#include "test/util/logging.h"
+#include "test/util/posix_error.h"
+#include "test/util/temp_path.h"
+#include "test/util/test_util.h"
+
+namespace gvisor {
+namespace testing {
+
+namespace {
+
+// The syscall_test target runs runsc with --core-pattern=core.%p. On Linux,
+// core files are written to the working directory of the crashing process
+// unless core_pattern names a directory or a pipe.
+bool CoreDumpsToWorkingDirectory() {
+  if (IsRunningOnGvisor()) {
+    // Core dump files require VFS2.
+    return !IsRunningWithVFS1();
+  }
+  auto pattern = GetContents("/proc/sys/kernel/core_pattern");
+  if (!pattern.ok()) {
+    return false;
+  }
+  return !absl::StartsWith(pattern.ValueOrDie(), "|") &&
+         !absl::StrContains(pattern.ValueOrDie(), "/");
+}
+
+// CrashInDir forks a child that kills itself with SIGABRT in dir, with
+// RLIMIT_CORE set to limit, and returns its PID.
+pid_t CrashInDir(const std::string& dir, rlim_t limit) {
+  pid_t pid = fork();
+  if (pid == 0) {
+    TEST_PCHECK(chdir(dir.c_str()) == 0);
+    struct rlimit rl = {limit, limit};
+    TEST_PCHECK(setrlimit(RLIMIT_CORE, &rl) == 0);
+    TEST_PCHECK(prctl(PR_SET_DUMPABLE, 1) == 0);
+    raise(SIGABRT);
+    _exit(1);
+  }
+  return pid;
+}
+
+// WaitCoreDumped waits for the child pid, which must have been killed by
+// SIGABRT with a core dump.
+void WaitCoreDumped(pid_t pid) {
+  // Check the SIGCHLD siginfo before reaping the child.
+  siginfo_t info = {};
+  ASSERT_THAT(waitid(P_PID, pid, &info, WEXITED | WNOWAIT),
+              SyscallSucceeds());
+  EXPECT_EQ(info.si_code, CLD_DUMPED);
+  EXPECT_EQ(info.si_status, SIGABRT);
+
+  int status;
+  ASSERT_THAT(RetryEINTR(waitpid)(pid, &status, 0),
+              SyscallSucceedsWithValue(pid));
+  EXPECT_TRUE(WIFSIGNALED(status)) << status;
+  EXPECT_EQ(WTERMSIG(status), SIGABRT) << status;
+  EXPECT_TRUE(WCOREDUMP(status)) << status;
+}
+
+// CoreFile returns the path of the only core file in dir.
+PosixErrorOr<std::string> CoreFile(const std::string& dir) {
+  ASSIGN_OR_RETURN_ERRNO(std::vector<std::string> files,
+                         ListDir(dir, /* skipdots = */ true));
+  if (files.size() != 1 || !absl::StartsWith(files[0], "core")) {
+    return PosixError(ENOENT, absl::StrCat("no single core file in ", dir));
+  }
+  return JoinPath(dir, files[0]);
+}
+
+TEST(CoreDumpTest, CoreFile) {
+  SKIP_IF(!CoreDumpsToWorkingDirectory());
+
+  auto dir = ASSERT_NO_ERRNO_AND_VALUE(TempPath::CreateDir());
+  pid_t pid = CrashInDir(dir.path(), RLIM_INFINITY);
+  ASSERT_THAT(pid, SyscallSucceeds());
+  ASSERT_NO_FATAL_FAILURE(WaitCoreDumped(pid));
+
+  std::string path = ASSERT_NO_ERRNO_AND_VALUE(CoreFile(dir.path()));
+  std::string core = ASSERT_NO_ERRNO_AND_VALUE(GetContents(path));
+
+  ASSERT_GE(core.size(), sizeof(Elf64_Ehdr));
+  Elf64_Ehdr ehdr;
+  memcpy(&ehdr, core.data(), sizeof(ehdr));
+  ASSERT_EQ(memcmp(ehdr.e_ident, ELFMAG, SELFMAG), 0);
+  EXPECT_EQ(ehdr.e_type, ET_CORE);
+  ASSERT_LE(ehdr.e_phoff + ehdr.e_phnum * sizeof(Elf64_Phdr), core.size());
+
+  // Find the NT_PRSTATUS and NT_PRPSINFO notes in the PT_NOTE segment.
+  bool found_prstatus = false;
+  bool found_prpsinfo = false;
+  for (int i = 0; i < ehdr.e_phnum; i++) {
+    Elf64_Phdr phdr;
+    memcpy(&phdr, core.data() + ehdr.e_phoff + i * sizeof(phdr), sizeof(phdr));
+    if (phdr.p_type != PT_NOTE) {
+      continue;
+    }
+    ASSERT_LE(phdr.p_offset + phdr.p_filesz, core.size());
+    size_t off = phdr.p_offset;
+    size_t end = phdr.p_offset + phdr.p_filesz;
+    while (off + sizeof(Elf64_Nhdr) <= end) {
+      Elf64_Nhdr nhdr;
+      memcpy(&nhdr, core.data() + off, sizeof(nhdr));
+      off += sizeof(nhdr);
+      std::string name(core.data() + off, strnlen(core.data() + off,
+                                                  nhdr.n_namesz));
+      off += (nhdr.n_namesz + 3) & ~3;
+      ASSERT_LE(off + nhdr.n_descsz, end);
+      const char* desc = core.data() + off;
+      off += (nhdr.n_descsz + 3) & ~3;
+      if (name != "CORE") {
+        continue;
+      }
+      switch (nhdr.n_type) {
+        case NT_PRSTATUS: {
+          ASSERT_GE(nhdr.n_descsz, sizeof(struct elf_prstatus));
+          struct elf_prstatus prstatus;
+          memcpy(&prstatus, desc, sizeof(prstatus));
+          EXPECT_EQ(prstatus.pr_cursig, SIGABRT);
+          EXPECT_EQ(prstatus.pr_pid, pid);
+          EXPECT_EQ(prstatus.pr_ppid, getpid());
+          found_prstatus = true;
+          break;
+        }
+        case NT_PRPSINFO: {
+          ASSERT_GE(nhdr.n_descsz, sizeof(struct elf_prpsinfo));
+          struct elf_prpsinfo prpsinfo;
+          memcpy(&prpsinfo, desc, sizeof(prpsinfo));
+          EXPECT_EQ(prpsinfo.pr_pid, pid);
+          EXPECT_EQ(prpsinfo.pr_ppid, getpid());
+          found_prpsinfo = true;
+          break;
+        }
+      }
+    }
+  }
+  EXPECT_TRUE(found_prstatus);
+  EXPECT_TRUE(found_prpsinfo);
+}
+
+// A core dump that is truncated by RLIMIT_CORE is still reported as dumped.
+TEST(CoreDumpTest, Truncated) {
+  SKIP_IF(!CoreDumpsToWorkingDirectory());
+
+  const rlim_t limit = 2 * kPageSize;
+  auto dir = ASSERT_NO_ERRNO_AND_VALUE(TempPath::CreateDir());
+  pid_t pid = CrashInDir(dir.path(), limit);
+  ASSERT_THAT(pid, SyscallSucceeds());
+  ASSERT_NO_FATAL_FAILURE(WaitCoreDumped(pid));
+
+  std::string path = ASSERT_NO_ERRNO_AND_VALUE(CoreFile(dir.path()));
+  struct stat st = ASSERT_NO_ERRNO_AND_VALUE(Stat(path));
+  EXPECT_GT(st.st_size, 0);
+  EXPECT_LE(st.st_size, limit);
+}
+
+}  // namespace
+
+}  // namespace testing
+}  // namespace gvisor
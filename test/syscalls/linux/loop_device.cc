@@ -0,0 +1,146 @@
+// Copyright 2021 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+#include <fcntl.h>
+#include <linux/loop.h>
+#include <sys/ioctl.h>
+#include <sys/mount.h>
+#include <sys/stat.h>
+
+#include <string>
+
+#include "gmock/gmock.h"
+#include "gtest/gtest.h"
+#include "absl/strings/str_cat.h"
+#include "test/util/capability_util.h"
+#include "test/util/file_descriptor.h"
+#include "test/util/fs_util.h"
+#include "test/util/mount_util.h"
+#include "test/util/posix_error.h"
+#include "test/util/temp_path.h"
+#include "test/util/test_util.h"
+
+namespace gvisor {
+namespace testing {
+
+namespace {
+
+// kImage is an ext2 image containing kImageFile, see
+// pkg/sentry/fsimpl/ext/assets/README.md.
+constexpr char kImage[] = "pkg/sentry/fsimpl/ext/assets/tiny.ext2";
+constexpr char kImageFile[] = "pkg/sentry/fsimpl/ext/assets/file.txt";
+
+// OpenFreeLoopDevice returns an FD of an unbound loop device.
+PosixErrorOr<FileDescriptor> OpenFreeLoopDevice() {
+  ASSIGN_OR_RETURN_ERRNO(auto ctl, Open("/dev/loop-control", O_RDWR));
+  int number;
+  RETURN_ERROR_IF_SYSCALL_FAIL(number = ioctl(ctl.get(), LOOP_CTL_GET_FREE));
+  return Open(absl::StrCat("/dev/loop", number), O_RDWR);
+}
+
+// CopyImage returns a writable copy of kImage.
+PosixErrorOr<TempPath> CopyImage() {
+  ASSIGN_OR_RETURN_ERRNO(std::string image, GetContents(RunfilePath(kImage)));
+  return TempPath::CreateFileWith(GetAbsoluteTestTmpdir(), image, 0644);
+}
+
+class LoopDeviceTest : public ::testing::Test {
+ protected:
+  void SetUp() override {
+    // Loop devices are only implemented in VFS2.
+    SKIP_IF(IsRunningWithVFS1());
+    SKIP_IF(!ASSERT_NO_ERRNO_AND_VALUE(HaveCapability(CAP_SYS_ADMIN)));
+  }
+};
+
+TEST_F(LoopDeviceTest, SetStatusClear) {
+  auto const image = ASSERT_NO_ERRNO_AND_VALUE(CopyImage());
+  auto const image_fd = ASSERT_NO_ERRNO_AND_VALUE(Open(image.path(), O_RDWR));
+  auto const loop_fd = ASSERT_NO_ERRNO_AND_VALUE(OpenFreeLoopDevice());
+
+  // Unbound devices have no status.
+  struct loop_info64 info = {};
+  EXPECT_THAT(ioctl(loop_fd.get(), LOOP_GET_STATUS64, &info),
+              SyscallFailsWithErrno(ENXIO));
+
+  ASSERT_THAT(ioctl(loop_fd.get(), LOOP_SET_FD, image_fd.get()),
+              SyscallSucceeds());
+  // A bound device can't be bound again.
+  EXPECT_THAT(ioctl(loop_fd.get(), LOOP_SET_FD, image_fd.get()),
+              SyscallFailsWithErrno(EBUSY));
+
+  struct stat st;
+  ASSERT_THAT(fstat(image_fd.get(), &st), SyscallSucceeds());
+  ASSERT_THAT(ioctl(loop_fd.get(), LOOP_GET_STATUS64, &info),
+              SyscallSucceeds());
+  EXPECT_EQ(info.lo_inode, st.st_ino);
+  EXPECT_EQ(info.lo_offset, 0);
+  EXPECT_EQ(info.lo_flags & LO_FLAGS_READ_ONLY, 0);
+
+  // The device has the contents and size of the image.
+  uint64_t size;
+  ASSERT_THAT(ioctl(loop_fd.get(), BLKGETSIZE64, &size), SyscallSucceeds());
+  EXPECT_EQ(size, st.st_size);
+  std::string const want = ASSERT_NO_ERRNO_AND_VALUE(GetContents(image.path()));
+  std::string got(want.size(), '\0');
+  ASSERT_THAT(pread(loop_fd.get(), &got[0], got.size(), 0),
+              SyscallSucceedsWithValue(got.size()));
+  EXPECT_EQ(got, want);
+
+  ASSERT_THAT(ioctl(loop_fd.get(), LOOP_CLR_FD), SyscallSucceeds());
+  EXPECT_THAT(ioctl(loop_fd.get(), LOOP_GET_STATUS64, &info),
+              SyscallFailsWithErrno(ENXIO));
+  EXPECT_THAT(ioctl(loop_fd.get(), LOOP_CLR_FD), SyscallFailsWithErrno(ENXIO));
+}
+
+TEST_F(LoopDeviceTest, MountExt2) {
+  auto const image = ASSERT_NO_ERRNO_AND_VALUE(CopyImage());
+  auto const image_fd = ASSERT_NO_ERRNO_AND_VALUE(Open(image.path(), O_RDWR));
+  auto loop_fd = ASSERT_NO_ERRNO_AND_VALUE(OpenFreeLoopDevice());
+  ASSERT_THAT(ioctl(loop_fd.get(), LOOP_SET_FD, image_fd.get()),
+              SyscallSucceeds());
+
+  struct loop_info64 info = {};
+  ASSERT_THAT(ioctl(loop_fd.get(), LOOP_GET_STATUS64, &info),
+              SyscallSucceeds());
+  std::string const device = absl::StrCat("/dev/loop", info.lo_number);
+
+  auto const dir = ASSERT_NO_ERRNO_AND_VALUE(TempPath::CreateDir());
+  {
+    auto const mount = ASSERT_NO_ERRNO_AND_VALUE(
+        Mount(device, dir.path(), "ext2", MS_RDONLY, "", 0));
+    std::string const want =
+        ASSERT_NO_ERRNO_AND_VALUE(GetContents(RunfilePath(kImageFile)));
+    EXPECT_THAT(GetContents(JoinPath(dir.path(), "file.txt")),
+                IsPosixErrorOkAndHolds(want));
+
+    // While the device is mounted, LOOP_CLR_FD only marks it to be unbound
+    // once unmounted.
+    ASSERT_THAT(ioctl(loop_fd.get(), LOOP_CLR_FD), SyscallSucceeds());
+    ASSERT_THAT(ioctl(loop_fd.get(), LOOP_GET_STATUS64, &info),
+                SyscallSucceeds());
+    EXPECT_NE(info.lo_flags & LO_FLAGS_AUTOCLEAR, 0);
+  }
+
+  // Unmounting and closing the device unbinds it.
+  loop_fd.reset();
+  FileDescriptor const fd = ASSERT_NO_ERRNO_AND_VALUE(Open(device, O_RDONLY));
+  EXPECT_THAT(ioctl(fd.get(), LOOP_GET_STATUS64, &info),
+              SyscallFailsWithErrno(ENXIO));
+}
+
+}  // namespace
+
+}  // namespace testing
+}  // namespace gvisor
@@ -12,7 +12,9 @@
 // See the License for the specific language governing permissions and
 // limitations under the License.
 
+#include <fcntl.h>
 #include <net/if.h>
+#include <netinet/in.h>
 #include <sched.h>
 #include <sys/ioctl.h>
 #include <sys/socket.h>
@@ -22,6 +24,9 @@
 #include "gtest/gtest.h"
 #include "test/syscalls/linux/socket_test_util.h"
 #include "test/util/capability_util.h"
+#include "test/util/file_descriptor.h"
+#include "test/util/logging.h"
+#include "test/util/multiprocess_util.h"
 #include "test/util/posix_error.h"
 #include "test/util/test_util.h"
 #include "test/util/thread_util.h"
@@ -47,6 +52,82 @@ TEST(NetworkNamespaceTest, LoopbackExists) {
   });
 }
 
+TEST(NetworkNamespaceTest, Setns) {
+  SKIP_IF(!ASSERT_NO_ERRNO_AND_VALUE(HaveCapability(CAP_SYS_ADMIN)));
+  // setns(2) is only implemented with VFS2.
+  SKIP_IF(IsRunningWithVFS1());
+
+  ScopedThread t([&] {
+    const FileDescriptor orig_ns =
+        ASSERT_NO_ERRNO_AND_VALUE(Open("/proc/thread-self/ns/net", O_RDONLY));
+    ASSERT_THAT(unshare(CLONE_NEWNET), SyscallSucceeds());
+    const FileDescriptor new_ns =
+        ASSERT_NO_ERRNO_AND_VALUE(Open("/proc/thread-self/ns/net", O_RDONLY));
+
+    // Listen on the loopback address of the new namespace.
+    const FileDescriptor listener =
+        ASSERT_NO_ERRNO_AND_VALUE(Socket(AF_INET, SOCK_STREAM, 0));
+    struct sockaddr_in addr = {};
+    addr.sin_family = AF_INET;
+    addr.sin_addr.s_addr = htonl(INADDR_LOOPBACK);
+    socklen_t addrlen = sizeof(addr);
+    ASSERT_THAT(
+        bind(listener.get(), reinterpret_cast<struct sockaddr*>(&addr), addrlen),
+        SyscallSucceeds());
+    ASSERT_THAT(getsockname(listener.get(),
+                            reinterpret_cast<struct sockaddr*>(&addr), &addrlen),
+                SyscallSucceeds());
+    ASSERT_THAT(listen(listener.get(), 1), SyscallSucceeds());
+
+    // The listener is not reachable from the original namespace.
+    ASSERT_THAT(setns(orig_ns.get(), CLONE_NEWNET), SyscallSucceeds());
+    const FileDescriptor orig_conn =
+        ASSERT_NO_ERRNO_AND_VALUE(Socket(AF_INET, SOCK_STREAM, 0));
+    EXPECT_THAT(connect(orig_conn.get(),
+                        reinterpret_cast<struct sockaddr*>(&addr), addrlen),
+                SyscallFailsWithErrno(ECONNREFUSED));
+
+    // But it is once the new namespace is joined again.
+    ASSERT_THAT(setns(new_ns.get(), 0), SyscallSucceeds());
+    const FileDescriptor new_conn =
+        ASSERT_NO_ERRNO_AND_VALUE(Socket(AF_INET, SOCK_STREAM, 0));
+    EXPECT_THAT(connect(new_conn.get(),
+                        reinterpret_cast<struct sockaddr*>(&addr), addrlen),
+                SyscallSucceeds());
+  });
+}
+
+// Joining a network namespace requires CAP_SYS_ADMIN in the user namespace
+// that owns it, not only in the caller's user namespace.
+TEST(NetworkNamespaceTest, SetnsOwnedByOtherUserNamespace) {
+  SKIP_IF(IsRunningWithVFS1());
+  SKIP_IF(!ASSERT_NO_ERRNO_AND_VALUE(CanCreateUserNamespace()));
+
+  const auto rest = [] {
+    int orig_ns = open("/proc/thread-self/ns/net", O_RDONLY);
+    TEST_PCHECK(orig_ns >= 0);
+    // After this, the process has all capabilities in its new user
+    // namespace, which owns the new network namespace, but none in the user
+    // namespace that owns the original network namespace.
+    TEST_PCHECK(unshare(CLONE_NEWUSER | CLONE_NEWNET) == 0);
+    int new_ns = open("/proc/thread-self/ns/net", O_RDONLY);
+    TEST_PCHECK(new_ns >= 0);
+
+    TEST_CHECK(setns(orig_ns, CLONE_NEWNET) == -1 && errno == EPERM);
+    TEST_PCHECK(setns(new_ns, CLONE_NEWNET) == 0);
+  };
+  EXPECT_THAT(InForkedProcess(rest), IsPosixErrorOkAndHolds(0));
+}
+
+TEST(NetworkNamespaceTest, SetnsWrongType) {
+  SKIP_IF(!ASSERT_NO_ERRNO_AND_VALUE(HaveCapability(CAP_SYS_ADMIN)));
+  SKIP_IF(IsRunningWithVFS1());
+
+  const FileDescriptor ns =
+      ASSERT_NO_ERRNO_AND_VALUE(Open("/proc/thread-self/ns/net", O_RDONLY));
+  EXPECT_THAT(setns(ns.get(), CLONE_NEWPID), SyscallFailsWithErrno(EINVAL));
+}
+
 }  // namespace
 }  // namespace testing
 }  // namespace gvisor
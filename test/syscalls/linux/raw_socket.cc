@@ -897,6 +897,116 @@ TEST_P(RawSocketTest, ConnectOnIPv6Socket) {
               SyscallFailsWithErrno(EAFNOSUPPORT));
 }
 
+// Raw sockets may be created for protocols netstack doesn't implement, and
+// receive the packets of that protocol.
+TEST(RawSocketTest, UnknownProtocolSendAndReceive) {
+  SKIP_IF(!ASSERT_NO_ERRNO_AND_VALUE(HaveCapability(CAP_NET_RAW)));
+
+  // An experimental protocol number (RFC 3692).
+  constexpr int kProtocol = 253;
+  FileDescriptor sock =
+      ASSERT_NO_ERRNO_AND_VALUE(Socket(AF_INET, SOCK_RAW, kProtocol));
+
+  constexpr int kOn = 1;
+  ASSERT_THAT(setsockopt(sock.get(), SOL_IP, IP_RECVTTL, &kOn, sizeof(kOn)),
+              SyscallSucceeds());
+
+  struct sockaddr_in addr = {};
+  addr.sin_family = AF_INET;
+  addr.sin_addr.s_addr = htonl(INADDR_LOOPBACK);
+  char buf[] = "unknown protocol";
+  ASSERT_THAT(sendto(sock.get(), buf, sizeof(buf), 0,
+                     reinterpret_cast<struct sockaddr*>(&addr), sizeof(addr)),
+              SyscallSucceedsWithValue(sizeof(buf)));
+
+  char recv_buf[sizeof(struct iphdr) + sizeof(buf)];
+  struct iovec iov = {};
+  iov.iov_base = recv_buf;
+  iov.iov_len = sizeof(recv_buf);
+  char control[CMSG_SPACE(sizeof(int))] = {};
+  struct msghdr msg = {};
+  msg.msg_iov = &iov;
+  msg.msg_iovlen = 1;
+  msg.msg_control = control;
+  msg.msg_controllen = sizeof(control);
+  ASSERT_THAT(RetryEINTR(recvmsg)(sock.get(), &msg, 0),
+              SyscallSucceedsWithValue(sizeof(recv_buf)));
+
+  struct iphdr ip;
+  memcpy(&ip, recv_buf, sizeof(ip));
+  EXPECT_EQ(ip.protocol, kProtocol);
+  EXPECT_EQ(memcmp(recv_buf + sizeof(ip), buf, sizeof(buf)), 0);
+
+  struct cmsghdr* cmsg = CMSG_FIRSTHDR(&msg);
+  ASSERT_NE(cmsg, nullptr);
+  EXPECT_EQ(cmsg->cmsg_level, SOL_IP);
+  EXPECT_EQ(cmsg->cmsg_type, IP_TTL);
+  int ttl;
+  memcpy(&ttl, CMSG_DATA(cmsg), sizeof(ttl));
+  EXPECT_EQ(ttl, ip.ttl);
+}
+
+// IPv6 raw sockets report the hop limit and packet info of received packets
+// when requested.
+TEST(RawSocketTest, IPv6AncillaryData) {
+  SKIP_IF(!ASSERT_NO_ERRNO_AND_VALUE(HaveCapability(CAP_NET_RAW)));
+
+  FileDescriptor sock =
+      ASSERT_NO_ERRNO_AND_VALUE(Socket(AF_INET6, SOCK_RAW, IPPROTO_UDP));
+
+  constexpr int kOn = 1;
+  ASSERT_THAT(setsockopt(sock.get(), SOL_IPV6, IPV6_RECVHOPLIMIT, &kOn,
+                         sizeof(kOn)),
+              SyscallSucceeds());
+  ASSERT_THAT(
+      setsockopt(sock.get(), SOL_IPV6, IPV6_RECVPKTINFO, &kOn, sizeof(kOn)),
+      SyscallSucceeds());
+
+  struct sockaddr_in6 addr = {};
+  addr.sin6_family = AF_INET6;
+  addr.sin6_addr = in6addr_loopback;
+  char buf[] = "ancillary data";
+  ASSERT_THAT(sendto(sock.get(), buf, sizeof(buf), 0,
+                     reinterpret_cast<struct sockaddr*>(&addr), sizeof(addr)),
+              SyscallSucceedsWithValue(sizeof(buf)));
+
+  char recv_buf[sizeof(buf)];
+  struct iovec iov = {};
+  iov.iov_base = recv_buf;
+  iov.iov_len = sizeof(recv_buf);
+  char control[CMSG_SPACE(sizeof(in6_pktinfo)) + CMSG_SPACE(sizeof(int))] = {};
+  struct msghdr msg = {};
+  msg.msg_iov = &iov;
+  msg.msg_iovlen = 1;
+  msg.msg_control = control;
+  msg.msg_controllen = sizeof(control);
+  ASSERT_THAT(RetryEINTR(recvmsg)(sock.get(), &msg, 0),
+              SyscallSucceedsWithValue(sizeof(recv_buf)));
+
+  bool found_pktinfo = false;
+  bool found_hoplimit = false;
+  for (struct cmsghdr* cmsg = CMSG_FIRSTHDR(&msg); cmsg != nullptr;
+       cmsg = CMSG_NXTHDR(&msg, cmsg)) {
+    ASSERT_EQ(cmsg->cmsg_level, SOL_IPV6);
+    if (cmsg->cmsg_type == IPV6_PKTINFO) {
+      found_pktinfo = true;
+      in6_pktinfo pktinfo;
+      memcpy(&pktinfo, CMSG_DATA(cmsg), sizeof(pktinfo));
+      EXPECT_EQ(memcmp(&pktinfo.ipi6_addr, &in6addr_loopback,
+                       sizeof(in6addr_loopback)),
+                0);
+      EXPECT_NE(pktinfo.ipi6_ifindex, 0);
+    } else if (cmsg->cmsg_type == IPV6_HOPLIMIT) {
+      found_hoplimit = true;
+      int hop_limit;
+      memcpy(&hop_limit, CMSG_DATA(cmsg), sizeof(hop_limit));
+      EXPECT_GT(hop_limit, 0);
+    }
+  }
+  EXPECT_TRUE(found_pktinfo);
+  EXPECT_TRUE(found_hoplimit);
+}
+
 INSTANTIATE_TEST_SUITE_P(
     AllInetTests, RawSocketTest,
     ::testing::Combine(::testing::Values(IPPROTO_TCP, IPPROTO_UDP),
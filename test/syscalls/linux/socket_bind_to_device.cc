@@ -39,6 +39,11 @@
 #include "test/util/test_util.h"
 #include "test/util/thread_util.h"
 
+// SO_BINDTOIFINDEX is only defined by recent headers.
+#ifndef SO_BINDTOIFINDEX
+#define SO_BINDTOIFINDEX 62
+#endif
+
 namespace gvisor {
 namespace testing {
 
@@ -305,6 +310,43 @@ TEST_P(BindToDeviceTest, GetsockoptDevice) {
   }
 }
 
+// Tests that SO_BINDTOIFINDEX binds to the device with the given index.
+TEST_P(BindToDeviceTest, SetsockoptBindToIfindex) {
+  int ifindex = if_nametoindex(interface_name().c_str());
+  ASSERT_NE(ifindex, 0);
+
+  ASSERT_THAT(setsockopt(socket_fd(), SOL_SOCKET, SO_BINDTOIFINDEX, &ifindex,
+                         sizeof(ifindex)),
+              SyscallSucceeds());
+
+  // Read it back as a device name.
+  char name_buffer[IFNAMSIZ * 2];
+  socklen_t name_buffer_size = sizeof(name_buffer);
+  EXPECT_THAT(getsockopt(socket_fd(), SOL_SOCKET, SO_BINDTODEVICE, name_buffer,
+                         &name_buffer_size),
+              SyscallSucceeds());
+  EXPECT_STREQ(name_buffer, interface_name().c_str());
+
+  // Index 0 clears it.
+  int zero = 0;
+  ASSERT_THAT(
+      setsockopt(socket_fd(), SOL_SOCKET, SO_BINDTOIFINDEX, &zero, sizeof(zero)),
+      SyscallSucceeds());
+  name_buffer_size = sizeof(name_buffer);
+  EXPECT_THAT(getsockopt(socket_fd(), SOL_SOCKET, SO_BINDTODEVICE, name_buffer,
+                         &name_buffer_size),
+              SyscallSucceeds());
+  EXPECT_EQ(name_buffer_size, 0);
+}
+
+// Tests that SO_BINDTOIFINDEX fails for unknown devices.
+TEST_P(BindToDeviceTest, SetsockoptBindToUnknownIfindex) {
+  int ifindex = 0x7fffffff;
+  EXPECT_THAT(setsockopt(socket_fd(), SOL_SOCKET, SO_BINDTOIFINDEX, &ifindex,
+                         sizeof(ifindex)),
+              SyscallFailsWithErrno(ENODEV));
+}
+
 INSTANTIATE_TEST_SUITE_P(BindToDeviceTest, BindToDeviceTest,
                          ::testing::Values(IPv4UDPUnboundSocket(0),
                                            IPv4TCPUnboundSocket(0)));
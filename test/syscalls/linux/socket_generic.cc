@@ -131,6 +131,31 @@ TEST_P(AllSocketPairTest, BasicRecvmmsg) {
   }
 }
 
+TEST_P(AllSocketPairTest, RecvmmsgWaitForOne) {
+  auto sockets = ASSERT_NO_ERRNO_AND_VALUE(NewSocketPair());
+  char sent_data[20];
+  RandomizeBuffer(sent_data, sizeof(sent_data));
+  ASSERT_THAT(WriteFd(sockets->first_fd(), sent_data, sizeof(sent_data)),
+              SyscallSucceedsWithValue(sizeof(sent_data)));
+
+  char received_data[2][sizeof(sent_data)];
+  struct mmsghdr msgs[2] = {};
+  struct iovec iovs[2];
+  for (int i = 0; i < 2; i++) {
+    iovs[i].iov_base = received_data[i];
+    iovs[i].iov_len = sizeof(received_data[i]);
+    msgs[i].msg_hdr.msg_iov = &iovs[i];
+    msgs[i].msg_hdr.msg_iovlen = 1;
+  }
+
+  // Only the first message is waited for.
+  ASSERT_THAT(RetryEINTR(recvmmsg)(sockets->second_fd(), msgs, 2,
+                                   MSG_WAITFORONE, nullptr),
+              SyscallSucceedsWithValue(1));
+  EXPECT_EQ(msgs[0].msg_len, sizeof(sent_data));
+  EXPECT_EQ(0, memcmp(sent_data, received_data[0], sizeof(sent_data)));
+}
+
 TEST_P(AllSocketPairTest, SendmsgRecvmsg10KB) {
   auto sockets = ASSERT_NO_ERRNO_AND_VALUE(NewSocketPair());
   std::vector<char> sent_data(10 * 1024);
@@ -15,6 +15,7 @@
 #include "test/syscalls/linux/socket_ip_tcp_generic.h"
 
 #include <fcntl.h>
+#include <linux/tls.h>
 #include <netinet/in.h>
 #include <netinet/tcp.h>
 #include <poll.h>
@@ -35,6 +36,10 @@
 namespace gvisor {
 namespace testing {
 
+#ifndef SOL_TLS
+#define SOL_TLS 282
+#endif
+
 using ::testing::AnyOf;
 using ::testing::Eq;
 
@@ -1250,5 +1255,130 @@ TEST_P(TCPSocketPairTest, CloseWithLingerOption) {
   ASSERT_THAT(RetryEINTR(write)(dupFd.get(), buf, sizeof(buf)),
               SyscallFailsWithErrno(EBADF));
 }
+
+TEST_P(TCPSocketPairTest, PktOptions) {
+  auto sockets = ASSERT_NO_ERRNO_AND_VALUE(NewSocketPair());
+
+  // Without any enabled control message, there is nothing to return.
+  char buf[CMSG_SPACE(sizeof(struct in_pktinfo)) + CMSG_SPACE(sizeof(int))];
+  socklen_t buf_len = sizeof(buf);
+  ASSERT_THAT(
+      getsockopt(sockets->first_fd(), SOL_IP, IP_PKTOPTIONS, buf, &buf_len),
+      SyscallSucceeds());
+  EXPECT_EQ(buf_len, 0);
+
+  ASSERT_THAT(setsockopt(sockets->first_fd(), SOL_IP, IP_PKTINFO, &kSockOptOn,
+                         sizeof(kSockOptOn)),
+              SyscallSucceeds());
+  ASSERT_THAT(setsockopt(sockets->first_fd(), SOL_IP, IP_RECVTTL, &kSockOptOn,
+                         sizeof(kSockOptOn)),
+              SyscallSucceeds());
+
+  buf_len = sizeof(buf);
+  ASSERT_THAT(
+      getsockopt(sockets->first_fd(), SOL_IP, IP_PKTOPTIONS, buf, &buf_len),
+      SyscallSucceeds());
+  ASSERT_EQ(buf_len, sizeof(buf));
+
+  struct msghdr msg = {};
+  msg.msg_control = buf;
+  msg.msg_controllen = buf_len;
+  struct cmsghdr* cmsg = CMSG_FIRSTHDR(&msg);
+  ASSERT_NE(cmsg, nullptr);
+  EXPECT_EQ(cmsg->cmsg_level, SOL_IP);
+  EXPECT_EQ(cmsg->cmsg_type, IP_PKTINFO);
+  EXPECT_EQ(cmsg->cmsg_len, CMSG_LEN(sizeof(struct in_pktinfo)));
+
+  cmsg = CMSG_NXTHDR(&msg, cmsg);
+  ASSERT_NE(cmsg, nullptr);
+  EXPECT_EQ(cmsg->cmsg_level, SOL_IP);
+  EXPECT_EQ(cmsg->cmsg_type, IP_TTL);
+  ASSERT_EQ(cmsg->cmsg_len, CMSG_LEN(sizeof(int)));
+  int ttl = 0;
+  memcpy(&ttl, CMSG_DATA(cmsg), sizeof(ttl));
+  // The multicast TTL, which defaults to 1.
+  EXPECT_EQ(ttl, 1);
+}
+
+// Sets up kTLS on fd with a fixed AES-GCM-128 key, for the direction selected
+// by optname.
+PosixError SetKTLS(int fd, int optname) {
+  struct tls12_crypto_info_aes_gcm_128 info = {};
+  info.info.version = TLS_1_2_VERSION;
+  info.info.cipher_type = TLS_CIPHER_AES_GCM_128;
+  memset(info.key, 0x2a, sizeof(info.key));
+  memset(info.salt, 0x17, sizeof(info.salt));
+  RETURN_ERROR_IF_SYSCALL_FAIL(
+      setsockopt(fd, SOL_TLS, optname, &info, sizeof(info)));
+  return NoError();
+}
+
+TEST_P(TCPSocketPairTest, KTLSRoundTrip) {
+  auto sockets = ASSERT_NO_ERRNO_AND_VALUE(NewSocketPair());
+
+  // The TLS options are only available once the ULP is set.
+  struct tls12_crypto_info_aes_gcm_128 info = {};
+  EXPECT_THAT(setsockopt(sockets->first_fd(), SOL_TLS, TLS_TX, &info,
+                         sizeof(info)),
+              SyscallFailsWithErrno(ENOPROTOOPT));
+
+  int ret = setsockopt(sockets->first_fd(), SOL_TCP, TCP_ULP, "tls",
+                       sizeof("tls"));
+  if (ret < 0 && errno == ENOENT) {
+    // The tls module isn't loaded on the host.
+    GTEST_SKIP();
+  }
+  ASSERT_THAT(ret, SyscallSucceeds());
+  EXPECT_THAT(setsockopt(sockets->first_fd(), SOL_TCP, TCP_ULP, "tls",
+                         sizeof("tls")),
+              SyscallFailsWithErrno(EEXIST));
+  ASSERT_THAT(setsockopt(sockets->second_fd(), SOL_TCP, TCP_ULP, "tls",
+                         sizeof("tls")),
+              SyscallSucceeds());
+
+  char ulp[16] = {};
+  socklen_t ulp_len = sizeof(ulp);
+  ASSERT_THAT(
+      getsockopt(sockets->first_fd(), SOL_TCP, TCP_ULP, ulp, &ulp_len),
+      SyscallSucceeds());
+  EXPECT_STREQ(ulp, "tls");
+
+  ASSERT_NO_ERRNO(SetKTLS(sockets->first_fd(), TLS_TX));
+  EXPECT_THAT(SetKTLS(sockets->first_fd(), TLS_TX), PosixErrorIs(EBUSY, ::testing::_));
+  ASSERT_NO_ERRNO(SetKTLS(sockets->second_fd(), TLS_RX));
+
+  socklen_t info_len = sizeof(info);
+  ASSERT_THAT(
+      getsockopt(sockets->first_fd(), SOL_TLS, TLS_TX, &info, &info_len),
+      SyscallSucceeds());
+  EXPECT_EQ(info_len, sizeof(info));
+  EXPECT_EQ(info.info.version, TLS_1_2_VERSION);
+  EXPECT_EQ(info.info.cipher_type, TLS_CIPHER_AES_GCM_128);
+
+  constexpr char kData[] = "hello kTLS";
+  ASSERT_THAT(
+      RetryEINTR(send)(sockets->first_fd(), kData, sizeof(kData), 0),
+      SyscallSucceedsWithValue(sizeof(kData)));
+
+  char buf[sizeof(kData)] = {};
+  char control[CMSG_SPACE(sizeof(unsigned char))] = {};
+  struct iovec iov = {buf, sizeof(buf)};
+  struct msghdr msg = {};
+  msg.msg_iov = &iov;
+  msg.msg_iovlen = 1;
+  msg.msg_control = control;
+  msg.msg_controllen = sizeof(control);
+  ASSERT_THAT(RetryEINTR(recvmsg)(sockets->second_fd(), &msg, MSG_WAITALL),
+              SyscallSucceedsWithValue(sizeof(kData)));
+  EXPECT_EQ(memcmp(buf, kData, sizeof(kData)), 0);
+
+  struct cmsghdr* cmsg = CMSG_FIRSTHDR(&msg);
+  ASSERT_NE(cmsg, nullptr);
+  EXPECT_EQ(cmsg->cmsg_level, SOL_TLS);
+  EXPECT_EQ(cmsg->cmsg_type, TLS_GET_RECORD_TYPE);
+  // Application data.
+  EXPECT_EQ(*CMSG_DATA(cmsg), 23);
+}
+
 }  // namespace testing
 }  // namespace gvisor
@@ -0,0 +1,187 @@
+// Copyright 2021 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+#include <linux/audit.h>
+#include <linux/netlink.h>
+#include <poll.h>
+#include <sys/socket.h>
+#include <sys/syscall.h>
+#include <sys/types.h>
+#include <sys/un.h>
+#include <unistd.h>
+
+#include <cstring>
+#include <string>
+
+#include "gtest/gtest.h"
+#include "absl/strings/match.h"
+#include "absl/strings/str_cat.h"
+#include "test/syscalls/linux/socket_netlink_util.h"
+#include "test/syscalls/linux/socket_test_util.h"
+#include "test/util/capability_util.h"
+#include "test/util/cleanup.h"
+#include "test/util/file_descriptor.h"
+#include "test/util/posix_error.h"
+#include "test/util/test_util.h"
+
+// Tests for NETLINK_AUDIT sockets.
+//
+// On Linux, the audit configuration is global to the host, so tests that
+// change it only run in gVisor.
+
+namespace gvisor {
+namespace testing {
+
+namespace {
+
+constexpr uint32_t kSeq = 12345;
+
+struct AuditStatusRequest {
+  struct nlmsghdr hdr;
+  struct audit_status status;
+};
+
+// GetAuditStatus returns the audit status, as reported by AUDIT_GET.
+PosixErrorOr<struct audit_status> GetAuditStatus(const FileDescriptor& fd) {
+  AuditStatusRequest req = {};
+  req.hdr.nlmsg_len = NLMSG_LENGTH(0);
+  req.hdr.nlmsg_type = AUDIT_GET;
+  req.hdr.nlmsg_flags = NLM_F_REQUEST;
+  req.hdr.nlmsg_seq = kSeq;
+
+  struct audit_status status = {};
+  bool found = false;
+  RETURN_IF_ERRNO(NetlinkRequestResponseSingle(
+      fd, &req, req.hdr.nlmsg_len, [&](const struct nlmsghdr* hdr) {
+        if (hdr->nlmsg_type != AUDIT_GET ||
+            hdr->nlmsg_len < NLMSG_LENGTH(sizeof(status))) {
+          return;
+        }
+        memcpy(&status, NLMSG_DATA(hdr), sizeof(status));
+        found = true;
+      }));
+  if (!found) {
+    return PosixError(EINVAL, "no AUDIT_GET response");
+  }
+  return status;
+}
+
+// SetAuditStatus sets the fields of the audit status in mask with AUDIT_SET.
+PosixError SetAuditStatus(const FileDescriptor& fd, uint32_t mask,
+                          uint32_t enabled, uint32_t pid) {
+  AuditStatusRequest req = {};
+  req.hdr.nlmsg_len = sizeof(req);
+  req.hdr.nlmsg_type = AUDIT_SET;
+  req.hdr.nlmsg_flags = NLM_F_REQUEST | NLM_F_ACK;
+  req.hdr.nlmsg_seq = kSeq;
+  req.status.mask = mask;
+  req.status.enabled = enabled;
+  req.status.pid = pid;
+  return NetlinkRequestAckOrError(fd, kSeq, &req, sizeof(req));
+}
+
+TEST(NetlinkAuditTest, GetStatus) {
+  SKIP_IF(!ASSERT_NO_ERRNO_AND_VALUE(HaveCapability(CAP_AUDIT_CONTROL)));
+
+  FileDescriptor fd =
+      ASSERT_NO_ERRNO_AND_VALUE(NetlinkBoundSocket(NETLINK_AUDIT));
+  struct audit_status status = ASSERT_NO_ERRNO_AND_VALUE(GetAuditStatus(fd));
+  EXPECT_LE(status.enabled, 2u);
+  EXPECT_LE(status.failure, 2u);
+}
+
+TEST(NetlinkAuditTest, SetInvalidEnabled) {
+  SKIP_IF(!ASSERT_NO_ERRNO_AND_VALUE(HaveCapability(CAP_AUDIT_CONTROL)));
+
+  FileDescriptor fd =
+      ASSERT_NO_ERRNO_AND_VALUE(NetlinkBoundSocket(NETLINK_AUDIT));
+  EXPECT_THAT(SetAuditStatus(fd, AUDIT_STATUS_ENABLED, 3, 0),
+              PosixErrorIs(EINVAL, ::testing::_));
+}
+
+TEST(NetlinkAuditTest, RegisterOtherPID) {
+  SKIP_IF(!IsRunningOnGvisor());
+  SKIP_IF(!ASSERT_NO_ERRNO_AND_VALUE(HaveCapability(CAP_AUDIT_CONTROL)));
+
+  // auditd may only register itself.
+  FileDescriptor fd =
+      ASSERT_NO_ERRNO_AND_VALUE(NetlinkBoundSocket(NETLINK_AUDIT));
+  EXPECT_THAT(SetAuditStatus(fd, AUDIT_STATUS_PID, 0, getpid() + 1),
+              PosixErrorIs(EINVAL, ::testing::_));
+}
+
+// Once auditing is enabled and the test registers as auditd, it receives the
+// records of connect(2).
+TEST(NetlinkAuditTest, SyscallRecord) {
+  SKIP_IF(!IsRunningOnGvisor());
+  SKIP_IF(!ASSERT_NO_ERRNO_AND_VALUE(HaveCapability(CAP_AUDIT_CONTROL)));
+
+  FileDescriptor fd =
+      ASSERT_NO_ERRNO_AND_VALUE(NetlinkBoundSocket(NETLINK_AUDIT));
+  ASSERT_NO_ERRNO(SetAuditStatus(fd, AUDIT_STATUS_ENABLED | AUDIT_STATUS_PID,
+                                 1, getpid()));
+  Cleanup disable([&fd] {
+    EXPECT_NO_ERRNO(
+        SetAuditStatus(fd, AUDIT_STATUS_ENABLED | AUDIT_STATUS_PID, 0, 0));
+  });
+
+  struct audit_status status = ASSERT_NO_ERRNO_AND_VALUE(GetAuditStatus(fd));
+  EXPECT_EQ(status.enabled, 1u);
+  EXPECT_EQ(status.pid, static_cast<uint32_t>(getpid()));
+
+  // A second socket can't register while the first is auditd.
+  FileDescriptor other =
+      ASSERT_NO_ERRNO_AND_VALUE(NetlinkBoundSocket(NETLINK_AUDIT));
+  EXPECT_THAT(SetAuditStatus(other, AUDIT_STATUS_PID, 0, getpid()),
+              PosixErrorIs(EEXIST, ::testing::_));
+
+  FileDescriptor sock =
+      ASSERT_NO_ERRNO_AND_VALUE(Socket(AF_UNIX, SOCK_STREAM, 0));
+  struct sockaddr_un addr = {};
+  addr.sun_family = AF_UNIX;
+  strncpy(addr.sun_path, "/nonexistent/audit.sock", sizeof(addr.sun_path) - 1);
+  ASSERT_THAT(connect(sock.get(), reinterpret_cast<struct sockaddr*>(&addr),
+                      sizeof(addr)),
+              SyscallFails());
+
+  // Look for the AUDIT_SYSCALL record of the failed connect among the
+  // records of any other audited syscalls.
+  const std::string want = absl::StrCat("syscall=", SYS_connect, " success=no");
+  bool found = false;
+  while (!found) {
+    struct pollfd pfd = {fd.get(), POLLIN, 0};
+    int n;
+    ASSERT_THAT(n = RetryEINTR(poll)(&pfd, 1, 10000), SyscallSucceeds());
+    ASSERT_EQ(n, 1) << "timed out waiting for the record of connect";
+
+    char buf[8192];
+    ssize_t len;
+    ASSERT_THAT(len = recv(fd.get(), buf, sizeof(buf), MSG_DONTWAIT),
+                SyscallSucceeds());
+    const struct nlmsghdr* hdr = reinterpret_cast<struct nlmsghdr*>(buf);
+    ASSERT_TRUE(NLMSG_OK(hdr, len));
+    if (hdr->nlmsg_type != AUDIT_SYSCALL) {
+      continue;
+    }
+    std::string text(reinterpret_cast<const char*>(NLMSG_DATA(hdr)),
+                     NLMSG_PAYLOAD(hdr, 0));
+    EXPECT_TRUE(absl::StartsWith(text, "audit(")) << text;
+    found = absl::StrContains(text, want);
+  }
+}
+
+}  // namespace
+
+}  // namespace testing
+}  // namespace gvisor
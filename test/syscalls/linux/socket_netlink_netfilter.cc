@@ -0,0 +1,216 @@
+// Copyright 2020 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+#include <arpa/inet.h>
+#include <linux/netfilter/nfnetlink.h>
+#include <linux/netfilter/nfnetlink_conntrack.h>
+#include <linux/netlink.h>
+#include <string.h>
+#include <sys/socket.h>
+#include <unistd.h>
+
+#include <string>
+
+#include "gtest/gtest.h"
+#include "absl/strings/numbers.h"
+#include "absl/strings/str_cat.h"
+#include "test/syscalls/linux/socket_netlink_util.h"
+#include "test/util/capability_util.h"
+#include "test/util/cleanup.h"
+#include "test/util/file_descriptor.h"
+#include "test/util/fs_util.h"
+#include "test/util/test_util.h"
+
+// Tests for NETLINK_NETFILTER sockets.
+
+namespace gvisor {
+namespace testing {
+
+namespace {
+
+constexpr uint32_t kSeq = 12345;
+
+constexpr char kConntrackMax[] = "/proc/sys/net/netfilter/nf_conntrack_max";
+
+struct request {
+  struct nlmsghdr hdr;
+  struct nfgenmsg nfgen;
+};
+
+// Returns a ctnetlink request of the given message type.
+struct request ConntrackRequest(uint16_t type, uint16_t flags) {
+  struct request req = {};
+  req.hdr.nlmsg_len = sizeof(req);
+  req.hdr.nlmsg_type = (NFNL_SUBSYS_CTNETLINK << 8) | type;
+  req.hdr.nlmsg_flags = NLM_F_REQUEST | flags;
+  req.hdr.nlmsg_seq = kSeq;
+  req.nfgen.nfgen_family = AF_UNSPEC;
+  req.nfgen.version = NFNETLINK_V0;
+  return req;
+}
+
+// Returns the first attribute of the given type in the ctnetlink message hdr.
+const struct nlattr* FindNfAttr(const struct nlmsghdr* hdr, uint16_t type) {
+  const int len = hdr->nlmsg_len - NLMSG_LENGTH(sizeof(struct nfgenmsg));
+  const char* p = reinterpret_cast<const char*>(NLMSG_DATA(hdr)) +
+                  NLMSG_ALIGN(sizeof(struct nfgenmsg));
+  for (int off = 0; off + static_cast<int>(sizeof(struct nlattr)) <= len;) {
+    const struct nlattr* attr = reinterpret_cast<const struct nlattr*>(p + off);
+    if (attr->nla_len < sizeof(struct nlattr)) {
+      return nullptr;
+    }
+    if ((attr->nla_type & NLA_TYPE_MASK) == type) {
+      return attr;
+    }
+    off += NLA_ALIGN(attr->nla_len);
+  }
+  return nullptr;
+}
+
+TEST(NetlinkNetfilterTest, DumpConntrack) {
+  SKIP_IF(!ASSERT_NO_ERRNO_AND_VALUE(HaveCapability(CAP_NET_ADMIN)));
+
+  FileDescriptor fd =
+      ASSERT_NO_ERRNO_AND_VALUE(NetlinkBoundSocket(NETLINK_NETFILTER));
+
+  struct request req = ConntrackRequest(IPCTNL_MSG_CT_GET, NLM_F_DUMP);
+  ASSERT_NO_ERRNO(NetlinkRequestResponse(
+      fd, &req, sizeof(req),
+      [&](const struct nlmsghdr* hdr) {
+        EXPECT_EQ(hdr->nlmsg_type,
+                  (NFNL_SUBSYS_CTNETLINK << 8) | IPCTNL_MSG_CT_NEW);
+        EXPECT_NE(FindNfAttr(hdr, CTA_TUPLE_ORIG), nullptr);
+        EXPECT_NE(FindNfAttr(hdr, CTA_TUPLE_REPLY), nullptr);
+        EXPECT_NE(FindNfAttr(hdr, CTA_STATUS), nullptr);
+      },
+      false));
+}
+
+TEST(NetlinkNetfilterTest, GetStats) {
+  SKIP_IF(!ASSERT_NO_ERRNO_AND_VALUE(HaveCapability(CAP_NET_ADMIN)));
+  SKIP_IF(access(kConntrackMax, F_OK) != 0);
+
+  FileDescriptor fd =
+      ASSERT_NO_ERRNO_AND_VALUE(NetlinkBoundSocket(NETLINK_NETFILTER));
+
+  uint32_t max;
+  std::string contents = ASSERT_NO_ERRNO_AND_VALUE(GetContents(kConntrackMax));
+  ASSERT_TRUE(absl::SimpleAtoi(contents, &max));
+
+  struct request req = ConntrackRequest(IPCTNL_MSG_CT_GET_STATS, 0);
+  bool found = false;
+  ASSERT_NO_ERRNO(NetlinkRequestResponseSingle(
+      fd, &req, sizeof(req), [&](const struct nlmsghdr* hdr) {
+        ASSERT_EQ(hdr->nlmsg_type,
+                  (NFNL_SUBSYS_CTNETLINK << 8) | IPCTNL_MSG_CT_GET_STATS);
+        EXPECT_NE(FindNfAttr(hdr, CTA_STATS_GLOBAL_ENTRIES), nullptr);
+
+        const struct nlattr* attr =
+            FindNfAttr(hdr, CTA_STATS_GLOBAL_MAX_ENTRIES);
+        ASSERT_NE(attr, nullptr);
+        uint32_t value;
+        memcpy(&value, reinterpret_cast<const char*>(attr) + NLA_HDRLEN,
+               sizeof(value));
+        EXPECT_EQ(ntohl(value), max);
+        found = true;
+      }));
+  EXPECT_TRUE(found);
+}
+
+TEST(NetlinkNetfilterTest, DeleteUnknownConntrack) {
+  SKIP_IF(!ASSERT_NO_ERRNO_AND_VALUE(HaveCapability(CAP_NET_ADMIN)));
+
+  FileDescriptor fd =
+      ASSERT_NO_ERRNO_AND_VALUE(NetlinkBoundSocket(NETLINK_NETFILTER));
+
+  // A tuple for a connection that doesn't exist.
+  struct {
+    struct nlmsghdr hdr;
+    struct nfgenmsg nfgen;
+    struct nlattr orig;
+    struct nlattr ip;
+    struct nlattr src;
+    struct in_addr src_addr;
+    struct nlattr dst;
+    struct in_addr dst_addr;
+    struct nlattr proto;
+    struct nlattr num;
+    uint8_t num_value;
+    uint8_t num_pad[3];
+    struct nlattr src_port;
+    uint16_t src_port_value;
+    uint16_t src_port_pad;
+    struct nlattr dst_port;
+    uint16_t dst_port_value;
+    uint16_t dst_port_pad;
+  } req = {};
+  req.hdr.nlmsg_len = sizeof(req);
+  req.hdr.nlmsg_type = (NFNL_SUBSYS_CTNETLINK << 8) | IPCTNL_MSG_CT_DELETE;
+  req.hdr.nlmsg_flags = NLM_F_REQUEST | NLM_F_ACK;
+  req.hdr.nlmsg_seq = kSeq;
+  req.nfgen.nfgen_family = AF_INET;
+  req.nfgen.version = NFNETLINK_V0;
+  req.orig.nla_type = CTA_TUPLE_ORIG | NLA_F_NESTED;
+  req.orig.nla_len = sizeof(req) - offsetof(decltype(req), orig);
+  req.ip.nla_type = CTA_TUPLE_IP | NLA_F_NESTED;
+  req.ip.nla_len = offsetof(decltype(req), proto) - offsetof(decltype(req), ip);
+  req.src.nla_type = CTA_IP_V4_SRC;
+  req.src.nla_len = NLA_HDRLEN + sizeof(req.src_addr);
+  req.src_addr.s_addr = htonl(INADDR_LOOPBACK);
+  req.dst.nla_type = CTA_IP_V4_DST;
+  req.dst.nla_len = NLA_HDRLEN + sizeof(req.dst_addr);
+  req.dst_addr.s_addr = htonl(INADDR_LOOPBACK + 1);
+  req.proto.nla_type = CTA_TUPLE_PROTO | NLA_F_NESTED;
+  req.proto.nla_len = sizeof(req) - offsetof(decltype(req), proto);
+  req.num.nla_type = CTA_PROTO_NUM;
+  req.num.nla_len = NLA_HDRLEN + sizeof(req.num_value);
+  req.num_value = IPPROTO_UDP;
+  req.src_port.nla_type = CTA_PROTO_SRC_PORT;
+  req.src_port.nla_len = NLA_HDRLEN + sizeof(req.src_port_value);
+  req.src_port_value = htons(1234);
+  req.dst_port.nla_type = CTA_PROTO_DST_PORT;
+  req.dst_port.nla_len = NLA_HDRLEN + sizeof(req.dst_port_value);
+  req.dst_port_value = htons(5678);
+
+  EXPECT_THAT(NetlinkRequestAckOrError(fd, kSeq, &req, sizeof(req)),
+              PosixErrorIs(ENOENT, ::testing::_));
+}
+
+TEST(NetlinkNetfilterTest, SetConntrackMax) {
+  SKIP_IF(!ASSERT_NO_ERRNO_AND_VALUE(HaveCapability(CAP_NET_ADMIN)));
+  SKIP_IF(access(kConntrackMax, F_OK) != 0);
+
+  std::string old = ASSERT_NO_ERRNO_AND_VALUE(GetContents(kConntrackMax));
+  Cleanup restore(
+      [&] { EXPECT_NO_ERRNO(SetContents(kConntrackMax, old)); });
+
+  ASSERT_NO_ERRNO(SetContents(kConntrackMax, "4242"));
+  EXPECT_EQ(ASSERT_NO_ERRNO_AND_VALUE(GetContents(kConntrackMax)), "4242\n");
+  EXPECT_EQ(ASSERT_NO_ERRNO_AND_VALUE(
+                GetContents("/proc/sys/net/nf_conntrack_max")),
+            "4242\n");
+}
+
+TEST(NetlinkNetfilterTest, ConntrackCountReadOnly) {
+  SKIP_IF(!ASSERT_NO_ERRNO_AND_VALUE(HaveCapability(CAP_NET_ADMIN)));
+  SKIP_IF(access(kConntrackMax, F_OK) != 0);
+
+  EXPECT_THAT(SetContents("/proc/sys/net/netfilter/nf_conntrack_count", "1"),
+              PosixErrorIs(EACCES, ::testing::_));
+}
+
+}  // namespace
+
+}  // namespace testing
+}  // namespace gvisor
@@ -15,14 +15,18 @@
 #include <arpa/inet.h>
 #include <fcntl.h>
 #include <ifaddrs.h>
+#include <linux/fib_rules.h>
 #include <linux/if.h>
 #include <linux/netlink.h>
+#include <linux/pkt_sched.h>
 #include <linux/rtnetlink.h>
+#include <string.h>
 #include <sys/socket.h>
 #include <sys/types.h>
 #include <unistd.h>
 
 #include <iostream>
+#include <string>
 #include <vector>
 
 #include "gtest/gtest.h"
@@ -549,6 +553,440 @@ TEST(NetlinkRouteTest, AddAndRemoveAddr) {
               PosixErrorIs(EEXIST, ::testing::_));
 }
 
+TEST(NetlinkRouteTest, AddAndRemoveRoute) {
+  SKIP_IF(!ASSERT_NO_ERRNO_AND_VALUE(HaveCapability(CAP_NET_ADMIN)));
+  // Don't do cooperative save/restore because netstack state is not restored.
+  // TODO(gvisor.dev/issue/4595): enable cooperative save tests.
+  const DisableSave ds;
+
+  Link loopback_link = ASSERT_NO_ERRNO_AND_VALUE(LoopbackLink());
+
+  struct in_addr dst;
+  ASSERT_EQ(inet_pton(AF_INET, "10.254.0.0", &dst), 1);
+
+  // Create should succeed, as no such route exists.
+  ASSERT_NO_ERRNO(AddExclusiveRoute(loopback_link.index, AF_INET,
+                                    /*prefixlen=*/16, &dst, sizeof(dst)));
+
+  Cleanup defer_route_removal = Cleanup([loopback_link, dst] {
+    // First delete should succeed, as the route exists.
+    EXPECT_NO_ERRNO(DelRoute(loopback_link.index, AF_INET, /*prefixlen=*/16,
+                             &dst, sizeof(dst)));
+
+    // Second delete should fail, as the route no longer exists.
+    EXPECT_THAT(DelRoute(loopback_link.index, AF_INET, /*prefixlen=*/16, &dst,
+                         sizeof(dst)),
+                PosixErrorIs(ESRCH, ::testing::_));
+  });
+
+  // Create exclusive should fail, as we created the route above.
+  EXPECT_THAT(AddExclusiveRoute(loopback_link.index, AF_INET,
+                                /*prefixlen=*/16, &dst, sizeof(dst)),
+              PosixErrorIs(EEXIST, ::testing::_));
+}
+
+TEST(NetlinkRouteTest, SetLinkMtu) {
+  SKIP_IF(!ASSERT_NO_ERRNO_AND_VALUE(HaveCapability(CAP_NET_ADMIN)));
+  // Don't do cooperative save/restore because netstack state is not restored.
+  // TODO(gvisor.dev/issue/4595): enable cooperative save tests.
+  const DisableSave ds;
+
+  Link loopback_link = ASSERT_NO_ERRNO_AND_VALUE(LoopbackLink());
+  constexpr uint32_t kMtu = 1500;
+  ASSERT_GT(loopback_link.mtu, kMtu);
+
+  ASSERT_NO_ERRNO(LinkSetMtu(loopback_link.index, kMtu));
+  Cleanup defer_mtu_restore = Cleanup([loopback_link] {
+    EXPECT_NO_ERRNO(LinkSetMtu(loopback_link.index, loopback_link.mtu));
+  });
+
+  EXPECT_EQ(ASSERT_NO_ERRNO_AND_VALUE(LoopbackLink()).mtu, kMtu);
+}
+
+// GetRuleDump tests a RTM_GETRULE + NLM_F_DUMP request.
+TEST(NetlinkRouteTest, GetRuleDump) {
+  FileDescriptor fd =
+      ASSERT_NO_ERRNO_AND_VALUE(NetlinkBoundSocket(NETLINK_ROUTE));
+
+  struct request {
+    struct nlmsghdr hdr;
+    struct rtgenmsg rgm;
+  };
+
+  struct request req = {};
+  req.hdr.nlmsg_len = sizeof(req);
+  req.hdr.nlmsg_type = RTM_GETRULE;
+  req.hdr.nlmsg_flags = NLM_F_REQUEST | NLM_F_DUMP;
+  req.hdr.nlmsg_seq = kSeq;
+  req.rgm.rtgen_family = AF_INET;
+
+  bool main_table_found = false;
+  ASSERT_NO_ERRNO(NetlinkRequestResponse(
+      fd, &req, sizeof(req),
+      [&](const struct nlmsghdr* hdr) {
+        EXPECT_THAT(hdr->nlmsg_type, AnyOf(Eq(RTM_NEWRULE), Eq(NLMSG_DONE)));
+        if (hdr->nlmsg_type != RTM_NEWRULE) {
+          return;
+        }
+
+        // struct fib_rule_hdr has the same layout as struct rtmsg.
+        ASSERT_GE(hdr->nlmsg_len, NLMSG_SPACE(sizeof(struct rtmsg)));
+        const struct rtmsg* msg =
+            reinterpret_cast<const struct rtmsg*>(NLMSG_DATA(hdr));
+        EXPECT_EQ(msg->rtm_family, AF_INET);
+        if (msg->rtm_table == RT_TABLE_MAIN) {
+          main_table_found = true;
+        }
+      },
+      false));
+  EXPECT_TRUE(main_table_found);
+}
+
+// Adds or removes a rule selecting the table for packets with the mark.
+PosixError ModifyMarkRule(uint16_t type, uint16_t flags, uint32_t priority,
+                          uint32_t mark, uint32_t table) {
+  ASSIGN_OR_RETURN_ERRNO(FileDescriptor fd, NetlinkBoundSocket(NETLINK_ROUTE));
+
+  struct request {
+    struct nlmsghdr hdr;
+    struct fib_rule_hdr frh;
+    struct rtattr priority_rta;
+    uint32_t priority;
+    struct rtattr mark_rta;
+    uint32_t mark;
+    struct rtattr table_rta;
+    uint32_t table;
+  };
+
+  struct request req = {};
+  req.hdr.nlmsg_len = sizeof(req);
+  req.hdr.nlmsg_type = type;
+  req.hdr.nlmsg_flags = flags;
+  req.hdr.nlmsg_seq = kSeq;
+  req.frh.family = AF_INET;
+  req.frh.action = FR_ACT_TO_TBL;
+  req.priority_rta.rta_type = FRA_PRIORITY;
+  req.priority_rta.rta_len = RTA_LENGTH(sizeof(req.priority));
+  req.priority = priority;
+  req.mark_rta.rta_type = FRA_FWMARK;
+  req.mark_rta.rta_len = RTA_LENGTH(sizeof(req.mark));
+  req.mark = mark;
+  req.table_rta.rta_type = FRA_TABLE;
+  req.table_rta.rta_len = RTA_LENGTH(sizeof(req.table));
+  req.table = table;
+
+  return NetlinkRequestAckOrError(fd, kSeq, &req, sizeof(req));
+}
+
+// Returns the number of IPv4 rules with the priority and table.
+PosixErrorOr<int> CountRules(uint32_t priority, uint32_t table) {
+  ASSIGN_OR_RETURN_ERRNO(FileDescriptor fd, NetlinkBoundSocket(NETLINK_ROUTE));
+
+  struct request {
+    struct nlmsghdr hdr;
+    struct rtgenmsg rgm;
+  };
+
+  struct request req = {};
+  req.hdr.nlmsg_len = sizeof(req);
+  req.hdr.nlmsg_type = RTM_GETRULE;
+  req.hdr.nlmsg_flags = NLM_F_REQUEST | NLM_F_DUMP;
+  req.hdr.nlmsg_seq = kSeq;
+  req.rgm.rtgen_family = AF_INET;
+
+  int count = 0;
+  RETURN_IF_ERRNO(NetlinkRequestResponse(
+      fd, &req, sizeof(req),
+      [&](const struct nlmsghdr* hdr) {
+        if (hdr->nlmsg_type != RTM_NEWRULE) {
+          return;
+        }
+        // struct fib_rule_hdr has the same layout as struct rtmsg.
+        const struct rtmsg* msg =
+            reinterpret_cast<const struct rtmsg*>(NLMSG_DATA(hdr));
+        uint32_t rule_priority = 0;
+        uint32_t rule_table = 0;
+        int len = RTM_PAYLOAD(hdr);
+        for (const struct rtattr* rta = RTM_RTA(msg); RTA_OK(rta, len);
+             rta = RTA_NEXT(rta, len)) {
+          if (rta->rta_type == FRA_PRIORITY) {
+            rule_priority = *reinterpret_cast<const uint32_t*>(RTA_DATA(rta));
+          } else if (rta->rta_type == FRA_TABLE) {
+            rule_table = *reinterpret_cast<const uint32_t*>(RTA_DATA(rta));
+          }
+        }
+        if (rule_priority == priority && rule_table == table) {
+          count++;
+        }
+      },
+      false));
+  return count;
+}
+
+TEST(NetlinkRouteTest, AddAndRemoveRule) {
+  SKIP_IF(!ASSERT_NO_ERRNO_AND_VALUE(HaveCapability(CAP_NET_ADMIN)));
+  // Don't do cooperative save/restore because netstack state is not restored.
+  // TODO(gvisor.dev/issue/4595): enable cooperative save tests.
+  const DisableSave ds;
+
+  constexpr uint32_t kPriority = 1000;
+  constexpr uint32_t kMark = 0x1234;
+  constexpr uint32_t kTable = 100;
+
+  ASSERT_NO_ERRNO(ModifyMarkRule(
+      RTM_NEWRULE, NLM_F_REQUEST | NLM_F_CREATE | NLM_F_EXCL | NLM_F_ACK,
+      kPriority, kMark, kTable));
+  EXPECT_EQ(ASSERT_NO_ERRNO_AND_VALUE(CountRules(kPriority, kTable)), 1);
+
+  // Create exclusive should fail, as we created the rule above.
+  EXPECT_THAT(ModifyMarkRule(
+                  RTM_NEWRULE,
+                  NLM_F_REQUEST | NLM_F_CREATE | NLM_F_EXCL | NLM_F_ACK,
+                  kPriority, kMark, kTable),
+              PosixErrorIs(EEXIST, ::testing::_));
+
+  // First delete should succeed, as the rule exists.
+  ASSERT_NO_ERRNO(ModifyMarkRule(RTM_DELRULE, NLM_F_REQUEST | NLM_F_ACK,
+                                 kPriority, kMark, kTable));
+  EXPECT_EQ(ASSERT_NO_ERRNO_AND_VALUE(CountRules(kPriority, kTable)), 0);
+
+  // Second delete should fail, as the rule no longer exists.
+  EXPECT_THAT(ModifyMarkRule(RTM_DELRULE, NLM_F_REQUEST | NLM_F_ACK,
+                             kPriority, kMark, kTable),
+              PosixErrorIs(ENOENT, ::testing::_));
+}
+
+// Adds or changes a netem root qdisc with the latency in ticks on the link.
+PosixError NewNetemQdisc(uint16_t flags, int ifindex, uint32_t latency) {
+  ASSIGN_OR_RETURN_ERRNO(FileDescriptor fd, NetlinkBoundSocket(NETLINK_ROUTE));
+
+  struct request {
+    struct nlmsghdr hdr;
+    struct tcmsg tcm;
+    struct rtattr kind_rta;
+    char kind[8];
+    struct rtattr options_rta;
+    struct tc_netem_qopt qopt;
+  };
+
+  struct request req = {};
+  req.hdr.nlmsg_len = sizeof(req);
+  req.hdr.nlmsg_type = RTM_NEWQDISC;
+  req.hdr.nlmsg_flags = flags;
+  req.hdr.nlmsg_seq = kSeq;
+  req.tcm.tcm_family = AF_UNSPEC;
+  req.tcm.tcm_ifindex = ifindex;
+  req.tcm.tcm_parent = TC_H_ROOT;
+  req.kind_rta.rta_type = TCA_KIND;
+  req.kind_rta.rta_len = RTA_LENGTH(sizeof("netem"));
+  strncpy(req.kind, "netem", sizeof(req.kind));
+  req.options_rta.rta_type = TCA_OPTIONS;
+  req.options_rta.rta_len = RTA_LENGTH(sizeof(req.qopt));
+  req.qopt.latency = latency;
+  req.qopt.limit = 1000;
+
+  return NetlinkRequestAckOrError(fd, kSeq, &req, sizeof(req));
+}
+
+// Deletes the root qdisc of the link.
+PosixError DelRootQdisc(int ifindex) {
+  ASSIGN_OR_RETURN_ERRNO(FileDescriptor fd, NetlinkBoundSocket(NETLINK_ROUTE));
+
+  struct request {
+    struct nlmsghdr hdr;
+    struct tcmsg tcm;
+  };
+
+  struct request req = {};
+  req.hdr.nlmsg_len = sizeof(req);
+  req.hdr.nlmsg_type = RTM_DELQDISC;
+  req.hdr.nlmsg_flags = NLM_F_REQUEST | NLM_F_ACK;
+  req.hdr.nlmsg_seq = kSeq;
+  req.tcm.tcm_family = AF_UNSPEC;
+  req.tcm.tcm_ifindex = ifindex;
+  req.tcm.tcm_parent = TC_H_ROOT;
+
+  return NetlinkRequestAckOrError(fd, kSeq, &req, sizeof(req));
+}
+
+// Returns the kind of the root qdisc of the link.
+PosixErrorOr<std::string> RootQdiscKind(int ifindex) {
+  ASSIGN_OR_RETURN_ERRNO(FileDescriptor fd, NetlinkBoundSocket(NETLINK_ROUTE));
+
+  struct request {
+    struct nlmsghdr hdr;
+    struct tcmsg tcm;
+  };
+
+  struct request req = {};
+  req.hdr.nlmsg_len = sizeof(req);
+  req.hdr.nlmsg_type = RTM_GETQDISC;
+  req.hdr.nlmsg_flags = NLM_F_REQUEST | NLM_F_DUMP;
+  req.hdr.nlmsg_seq = kSeq;
+  req.tcm.tcm_family = AF_UNSPEC;
+
+  std::string kind;
+  RETURN_IF_ERRNO(NetlinkRequestResponse(
+      fd, &req, sizeof(req),
+      [&](const struct nlmsghdr* hdr) {
+        if (hdr->nlmsg_type != RTM_NEWQDISC) {
+          return;
+        }
+        const struct tcmsg* msg =
+            reinterpret_cast<const struct tcmsg*>(NLMSG_DATA(hdr));
+        if (msg->tcm_ifindex != ifindex || msg->tcm_parent != TC_H_ROOT) {
+          return;
+        }
+        int len = hdr->nlmsg_len - NLMSG_SPACE(sizeof(*msg));
+        for (const struct rtattr* rta = reinterpret_cast<const struct rtattr*>(
+                 reinterpret_cast<const char*>(msg) + NLMSG_ALIGN(sizeof(*msg)));
+             RTA_OK(rta, len); rta = RTA_NEXT(rta, len)) {
+          if (rta->rta_type == TCA_KIND) {
+            kind = reinterpret_cast<const char*>(RTA_DATA(rta));
+          }
+        }
+      },
+      false));
+  return kind;
+}
+
+TEST(NetlinkRouteTest, AddAndRemoveQdisc) {
+  SKIP_IF(!ASSERT_NO_ERRNO_AND_VALUE(HaveCapability(CAP_NET_ADMIN)));
+  // Don't do cooperative save/restore because netstack state is not restored.
+  // TODO(gvisor.dev/issue/4595): enable cooperative save tests.
+  const DisableSave ds;
+
+  Link loopback_link = ASSERT_NO_ERRNO_AND_VALUE(LoopbackLink());
+  // 1ms of latency, in packet scheduler ticks of 64ns.
+  constexpr uint32_t kLatency = 15625;
+
+  ASSERT_NO_ERRNO(
+      NewNetemQdisc(NLM_F_REQUEST | NLM_F_CREATE | NLM_F_EXCL | NLM_F_ACK,
+                    loopback_link.index, kLatency));
+  EXPECT_EQ(ASSERT_NO_ERRNO_AND_VALUE(RootQdiscKind(loopback_link.index)),
+            "netem");
+
+  // Create exclusive should fail, as we created the qdisc above.
+  EXPECT_THAT(
+      NewNetemQdisc(NLM_F_REQUEST | NLM_F_CREATE | NLM_F_EXCL | NLM_F_ACK,
+                    loopback_link.index, kLatency),
+      PosixErrorIs(EEXIST, ::testing::_));
+
+  // Changing the parameters of the existing qdisc should succeed.
+  ASSERT_NO_ERRNO(NewNetemQdisc(NLM_F_REQUEST | NLM_F_ACK, loopback_link.index,
+                                2 * kLatency));
+
+  // First delete should succeed, as the qdisc exists.
+  ASSERT_NO_ERRNO(DelRootQdisc(loopback_link.index));
+  EXPECT_NE(ASSERT_NO_ERRNO_AND_VALUE(RootQdiscKind(loopback_link.index)),
+            "netem");
+
+  // Second delete should fail, as only the default qdisc is left.
+  EXPECT_THAT(DelRootQdisc(loopback_link.index),
+              PosixErrorIs(ENOENT, ::testing::_));
+}
+
+// Creates a VXLAN link with the name and VNI.
+PosixError NewVXLANLink(uint16_t flags, const std::string& name, uint32_t vni) {
+  ASSIGN_OR_RETURN_ERRNO(FileDescriptor fd, NetlinkBoundSocket(NETLINK_ROUTE));
+
+  struct request {
+    struct nlmsghdr hdr;
+    struct ifinfomsg ifm;
+    struct rtattr name_rta;
+    char name[IFNAMSIZ];
+    struct rtattr linkinfo_rta;
+    struct rtattr kind_rta;
+    char kind[8];
+    struct rtattr data_rta;
+    struct rtattr id_rta;
+    uint32_t id;
+  };
+
+  struct request req = {};
+  req.hdr.nlmsg_len = sizeof(req);
+  req.hdr.nlmsg_type = RTM_NEWLINK;
+  req.hdr.nlmsg_flags = flags;
+  req.hdr.nlmsg_seq = kSeq;
+  req.ifm.ifi_family = AF_UNSPEC;
+  req.name_rta.rta_type = IFLA_IFNAME;
+  req.name_rta.rta_len = RTA_LENGTH(sizeof(req.name));
+  strncpy(req.name, name.c_str(), sizeof(req.name) - 1);
+  req.linkinfo_rta.rta_type = IFLA_LINKINFO;
+  req.linkinfo_rta.rta_len =
+      RTA_LENGTH(sizeof(req) - offsetof(struct request, kind_rta));
+  req.kind_rta.rta_type = IFLA_INFO_KIND;
+  req.kind_rta.rta_len = RTA_LENGTH(sizeof("vxlan"));
+  strncpy(req.kind, "vxlan", sizeof(req.kind));
+  req.data_rta.rta_type = IFLA_INFO_DATA;
+  req.data_rta.rta_len =
+      RTA_LENGTH(sizeof(req) - offsetof(struct request, id_rta));
+  req.id_rta.rta_type = IFLA_VXLAN_ID;
+  req.id_rta.rta_len = RTA_LENGTH(sizeof(req.id));
+  req.id = vni;
+
+  return NetlinkRequestAckOrError(fd, kSeq, &req, sizeof(req));
+}
+
+// Deletes the link.
+PosixError DelLink(int index) {
+  ASSIGN_OR_RETURN_ERRNO(FileDescriptor fd, NetlinkBoundSocket(NETLINK_ROUTE));
+
+  struct request {
+    struct nlmsghdr hdr;
+    struct ifinfomsg ifm;
+  };
+
+  struct request req = {};
+  req.hdr.nlmsg_len = sizeof(req);
+  req.hdr.nlmsg_type = RTM_DELLINK;
+  req.hdr.nlmsg_flags = NLM_F_REQUEST | NLM_F_ACK;
+  req.hdr.nlmsg_seq = kSeq;
+  req.ifm.ifi_family = AF_UNSPEC;
+  req.ifm.ifi_index = index;
+
+  return NetlinkRequestAckOrError(fd, kSeq, &req, sizeof(req));
+}
+
+// Returns the index of the link with the name, or 0 if there is none.
+PosixErrorOr<int> LinkIndexByName(const std::string& name) {
+  ASSIGN_OR_RETURN_ERRNO(std::vector<Link> links, DumpLinks());
+  for (const Link& link : links) {
+    if (link.name == name) {
+      return link.index;
+    }
+  }
+  return 0;
+}
+
+TEST(NetlinkRouteTest, AddAndRemoveVXLANLink) {
+  SKIP_IF(!ASSERT_NO_ERRNO_AND_VALUE(HaveCapability(CAP_NET_ADMIN)));
+  // Don't do cooperative save/restore because netstack state is not restored.
+  // TODO(gvisor.dev/issue/4595): enable cooperative save tests.
+  const DisableSave ds;
+
+  const std::string kName = "vxlantest0";
+  constexpr uint32_t kVNI = 42;
+
+  ASSERT_NO_ERRNO(NewVXLANLink(
+      NLM_F_REQUEST | NLM_F_CREATE | NLM_F_EXCL | NLM_F_ACK, kName, kVNI));
+  int index = ASSERT_NO_ERRNO_AND_VALUE(LinkIndexByName(kName));
+  ASSERT_GT(index, 0);
+
+  // Create exclusive should fail, as we created the link above.
+  EXPECT_THAT(NewVXLANLink(NLM_F_REQUEST | NLM_F_CREATE | NLM_F_EXCL |
+                               NLM_F_ACK,
+                           kName, kVNI),
+              PosixErrorIs(EEXIST, ::testing::_));
+
+  // First delete should succeed, as the link exists.
+  ASSERT_NO_ERRNO(DelLink(index));
+  EXPECT_EQ(ASSERT_NO_ERRNO_AND_VALUE(LinkIndexByName(kName)), 0);
+
+  // Second delete should fail, as the link is gone.
+  EXPECT_THAT(DelLink(index), PosixErrorIs(ENODEV, ::testing::_));
+}
+
 // GetRouteDump tests a RTM_GETROUTE + NLM_F_DUMP request.
 TEST(NetlinkRouteTest, GetRouteDump) {
   FileDescriptor fd =
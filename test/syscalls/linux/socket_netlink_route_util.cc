@@ -92,6 +92,47 @@ PosixError LinkModifyLocalAddr(int index, int family, int prefixlen,
   return NetlinkRequestAckOrError(fd, kSeq, &req, req.hdr.nlmsg_len);
 }
 
+// Adds or removes the specified route through the specified interface.
+PosixError ModifyRoute(int index, int family, int prefixlen, const void* dst,
+                       int dstlen, uint16_t type, uint16_t flags) {
+  ASSIGN_OR_RETURN_ERRNO(FileDescriptor fd, NetlinkBoundSocket(NETLINK_ROUTE));
+
+  struct request {
+    struct nlmsghdr hdr;
+    struct rtmsg rtm;
+    char attrbuf[512];
+  };
+
+  struct request req = {};
+  req.hdr.nlmsg_len = NLMSG_LENGTH(sizeof(req.rtm));
+  req.hdr.nlmsg_type = type;
+  req.hdr.nlmsg_flags = flags;
+  req.hdr.nlmsg_seq = kSeq;
+  req.rtm.rtm_family = family;
+  req.rtm.rtm_dst_len = prefixlen;
+  req.rtm.rtm_table = RT_TABLE_MAIN;
+  req.rtm.rtm_protocol = RTPROT_BOOT;
+  req.rtm.rtm_scope = RT_SCOPE_LINK;
+  req.rtm.rtm_type = RTN_UNICAST;
+
+  struct rtattr* rta = reinterpret_cast<struct rtattr*>(
+      reinterpret_cast<int8_t*>(&req) + NLMSG_ALIGN(req.hdr.nlmsg_len));
+  rta->rta_type = RTA_DST;
+  rta->rta_len = RTA_LENGTH(dstlen);
+  req.hdr.nlmsg_len = NLMSG_ALIGN(req.hdr.nlmsg_len) + RTA_LENGTH(dstlen);
+  memcpy(RTA_DATA(rta), dst, dstlen);
+
+  rta = reinterpret_cast<struct rtattr*>(reinterpret_cast<int8_t*>(&req) +
+                                         NLMSG_ALIGN(req.hdr.nlmsg_len));
+  rta->rta_type = RTA_OIF;
+  rta->rta_len = RTA_LENGTH(sizeof(index));
+  req.hdr.nlmsg_len =
+      NLMSG_ALIGN(req.hdr.nlmsg_len) + RTA_LENGTH(sizeof(index));
+  memcpy(RTA_DATA(rta), &index, sizeof(index));
+
+  return NetlinkRequestAckOrError(fd, kSeq, &req, req.hdr.nlmsg_len);
+}
+
 }  // namespace
 
 PosixError DumpLinks(
@@ -134,6 +175,10 @@ PosixErrorOr<std::vector<Link>> DumpLinks() {
     links.back().type = msg->ifi_type;
     links.back().name =
         std::string(reinterpret_cast<const char*>(RTA_DATA(rta)));
+    const auto* mtu_rta = FindRtAttr(hdr, msg, IFLA_MTU);
+    if (mtu_rta != nullptr) {
+      links.back().mtu = *reinterpret_cast<const uint32_t*>(RTA_DATA(mtu_rta));
+    }
   }));
   return links;
 }
@@ -219,5 +264,44 @@ PosixError LinkSetMacAddr(int index, const void* addr, int addrlen) {
   return NetlinkRequestAckOrError(fd, kSeq, &req, req.hdr.nlmsg_len);
 }
 
+PosixError LinkSetMtu(int index, uint32_t mtu) {
+  ASSIGN_OR_RETURN_ERRNO(FileDescriptor fd, NetlinkBoundSocket(NETLINK_ROUTE));
+
+  struct request {
+    struct nlmsghdr hdr;
+    struct ifinfomsg ifinfo;
+    char attrbuf[512];
+  };
+
+  struct request req = {};
+  req.hdr.nlmsg_len = NLMSG_LENGTH(sizeof(req.ifinfo));
+  req.hdr.nlmsg_type = RTM_NEWLINK;
+  req.hdr.nlmsg_flags = NLM_F_REQUEST | NLM_F_ACK;
+  req.hdr.nlmsg_seq = kSeq;
+  req.ifinfo.ifi_index = index;
+
+  struct rtattr* rta = reinterpret_cast<struct rtattr*>(
+      reinterpret_cast<int8_t*>(&req) + NLMSG_ALIGN(req.hdr.nlmsg_len));
+  rta->rta_type = IFLA_MTU;
+  rta->rta_len = RTA_LENGTH(sizeof(mtu));
+  req.hdr.nlmsg_len =
+      NLMSG_ALIGN(req.hdr.nlmsg_len) + RTA_LENGTH(sizeof(mtu));
+  memcpy(RTA_DATA(rta), &mtu, sizeof(mtu));
+
+  return NetlinkRequestAckOrError(fd, kSeq, &req, req.hdr.nlmsg_len);
+}
+
+PosixError AddExclusiveRoute(int index, int family, int prefixlen,
+                             const void* dst, int dstlen) {
+  return ModifyRoute(index, family, prefixlen, dst, dstlen, RTM_NEWROUTE,
+                     NLM_F_REQUEST | NLM_F_CREATE | NLM_F_EXCL | NLM_F_ACK);
+}
+
+PosixError DelRoute(int index, int family, int prefixlen, const void* dst,
+                    int dstlen) {
+  return ModifyRoute(index, family, prefixlen, dst, dstlen, RTM_DELROUTE,
+                     NLM_F_REQUEST | NLM_F_ACK);
+}
+
 }  // namespace testing
 }  // namespace gvisor
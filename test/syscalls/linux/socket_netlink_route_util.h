@@ -29,6 +29,7 @@ struct Link {
   int index;
   int16_t type;
   std::string name;
+  uint32_t mtu;
 };
 
 PosixError DumpLinks(const FileDescriptor& fd, uint32_t seq,
@@ -62,6 +63,18 @@ PosixError LinkChangeFlags(int index, unsigned int flags, unsigned int change);
 // LinkSetMacAddr sets IFLA_ADDRESS attribute of the interface.
 PosixError LinkSetMacAddr(int index, const void* addr, int addrlen);
 
+// LinkSetMtu sets IFLA_MTU attribute of the interface.
+PosixError LinkSetMtu(int index, uint32_t mtu);
+
+// AddExclusiveRoute adds a new unicast route in the main table through the
+// interface with NLM_F_EXCL flag.
+PosixError AddExclusiveRoute(int index, int family, int prefixlen,
+                             const void* dst, int dstlen);
+
+// DelRoute removes a route from the main table.
+PosixError DelRoute(int index, int family, int prefixlen, const void* dst,
+                    int dstlen);
+
 }  // namespace testing
 }  // namespace gvisor
 
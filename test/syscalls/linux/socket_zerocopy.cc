@@ -0,0 +1,148 @@
+// Copyright 2021 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+#include <linux/errqueue.h>
+#include <netinet/in.h>
+#include <poll.h>
+#include <sys/socket.h>
+#include <sys/types.h>
+
+#include <cstring>
+
+#include "gtest/gtest.h"
+#include "test/syscalls/linux/socket_test_util.h"
+#include "test/util/file_descriptor.h"
+#include "test/util/test_util.h"
+
+#ifndef SO_ZEROCOPY
+#define SO_ZEROCOPY 60
+#endif
+
+#ifndef MSG_ZEROCOPY
+#define MSG_ZEROCOPY 0x4000000
+#endif
+
+#ifndef SO_EE_ORIGIN_ZEROCOPY
+#define SO_EE_ORIGIN_ZEROCOPY 5
+#endif
+
+namespace gvisor {
+namespace testing {
+
+namespace {
+
+// ENOTSUPP is internal to Linux, and isn't defined by libc.
+constexpr int kENOTSUPP = 524;
+
+TEST(ZeroCopyTest, UnsupportedSockets) {
+  FileDescriptor unix_fd =
+      ASSERT_NO_ERRNO_AND_VALUE(Socket(AF_UNIX, SOCK_STREAM, 0));
+  EXPECT_THAT(setsockopt(unix_fd.get(), SOL_SOCKET, SO_ZEROCOPY, &kSockOptOn,
+                         sizeof(kSockOptOn)),
+              SyscallFailsWithErrno(kENOTSUPP));
+
+  // Ping sockets may not be permitted, in which case only AF_UNIX sockets
+  // are checked.
+  auto ping = Socket(AF_INET, SOCK_DGRAM, IPPROTO_ICMP);
+  if (ping.ok()) {
+    EXPECT_THAT(setsockopt(ping.ValueOrDie().get(), SOL_SOCKET, SO_ZEROCOPY,
+                           &kSockOptOn, sizeof(kSockOptOn)),
+                SyscallFailsWithErrno(kENOTSUPP));
+  }
+}
+
+TEST(ZeroCopyTest, GetSetTCP) {
+  FileDescriptor fd =
+      ASSERT_NO_ERRNO_AND_VALUE(Socket(AF_INET, SOCK_STREAM, IPPROTO_TCP));
+
+  int got = -1;
+  socklen_t len = sizeof(got);
+  ASSERT_THAT(getsockopt(fd.get(), SOL_SOCKET, SO_ZEROCOPY, &got, &len),
+              SyscallSucceeds());
+  EXPECT_EQ(got, 0);
+
+  ASSERT_THAT(setsockopt(fd.get(), SOL_SOCKET, SO_ZEROCOPY, &kSockOptOn,
+                         sizeof(kSockOptOn)),
+              SyscallSucceeds());
+  ASSERT_THAT(getsockopt(fd.get(), SOL_SOCKET, SO_ZEROCOPY, &got, &len),
+              SyscallSucceeds());
+  EXPECT_EQ(got, 1);
+
+  int invalid = 2;
+  EXPECT_THAT(setsockopt(fd.get(), SOL_SOCKET, SO_ZEROCOPY, &invalid,
+                         sizeof(invalid)),
+              SyscallFailsWithErrno(EINVAL));
+}
+
+// A MSG_ZEROCOPY send on a UDP socket is reported on the error queue.
+TEST(ZeroCopyTest, UDPCompletion) {
+  FileDescriptor rcv =
+      ASSERT_NO_ERRNO_AND_VALUE(Socket(AF_INET, SOCK_DGRAM, IPPROTO_UDP));
+  struct sockaddr_in addr = {};
+  addr.sin_family = AF_INET;
+  addr.sin_addr.s_addr = htonl(INADDR_LOOPBACK);
+  ASSERT_THAT(
+      bind(rcv.get(), reinterpret_cast<struct sockaddr*>(&addr), sizeof(addr)),
+      SyscallSucceeds());
+  socklen_t addrlen = sizeof(addr);
+  ASSERT_THAT(getsockname(rcv.get(), reinterpret_cast<struct sockaddr*>(&addr),
+                          &addrlen),
+              SyscallSucceeds());
+
+  FileDescriptor snd =
+      ASSERT_NO_ERRNO_AND_VALUE(Socket(AF_INET, SOCK_DGRAM, IPPROTO_UDP));
+  ASSERT_THAT(setsockopt(snd.get(), SOL_SOCKET, SO_ZEROCOPY, &kSockOptOn,
+                         sizeof(kSockOptOn)),
+              SyscallSucceeds());
+  ASSERT_THAT(setsockopt(snd.get(), SOL_IP, IP_RECVERR, &kSockOptOn,
+                         sizeof(kSockOptOn)),
+              SyscallSucceeds());
+  ASSERT_THAT(connect(snd.get(), reinterpret_cast<struct sockaddr*>(&addr),
+                      addrlen),
+              SyscallSucceeds());
+
+  char buf[1024] = {};
+  ASSERT_THAT(send(snd.get(), buf, sizeof(buf), MSG_ZEROCOPY),
+              SyscallSucceedsWithValue(sizeof(buf)));
+  ASSERT_THAT(recv(rcv.get(), buf, sizeof(buf), 0),
+              SyscallSucceedsWithValue(sizeof(buf)));
+
+  struct pollfd pfd = {snd.get(), 0, 0};
+  ASSERT_THAT(RetryEINTR(poll)(&pfd, 1, 10000), SyscallSucceedsWithValue(1));
+  EXPECT_TRUE(pfd.revents & POLLERR);
+
+  char control[CMSG_SPACE(sizeof(struct sock_extended_err) +
+                          sizeof(struct sockaddr_in))] = {};
+  struct msghdr msg = {};
+  msg.msg_control = control;
+  msg.msg_controllen = sizeof(control);
+  ASSERT_THAT(recvmsg(snd.get(), &msg, MSG_ERRQUEUE), SyscallSucceeds());
+
+  struct cmsghdr* cmsg = CMSG_FIRSTHDR(&msg);
+  ASSERT_NE(cmsg, nullptr);
+  EXPECT_EQ(cmsg->cmsg_level, SOL_IP);
+  EXPECT_EQ(cmsg->cmsg_type, IP_RECVERR);
+  struct sock_extended_err ee;
+  memcpy(&ee, CMSG_DATA(cmsg), sizeof(ee));
+  EXPECT_EQ(ee.ee_origin, SO_EE_ORIGIN_ZEROCOPY);
+  EXPECT_EQ(ee.ee_errno, 0u);
+  // The first send is numbered 0.
+  EXPECT_EQ(ee.ee_info, 0u);
+  EXPECT_EQ(ee.ee_data, 0u);
+}
+
+}  // namespace
+
+}  // namespace testing
+}  // namespace gvisor
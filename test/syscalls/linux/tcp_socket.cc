@@ -2031,6 +2031,177 @@ TEST_P(SimpleTcpSocketTest, ConnectUnspecifiedAddress) {
   }
 }
 
+TEST_P(SimpleTcpSocketTest, SetGetTCPFastOpen) {
+  FileDescriptor s =
+      ASSERT_NO_ERRNO_AND_VALUE(Socket(GetParam(), SOCK_STREAM, IPPROTO_TCP));
+
+  int get = -1;
+  socklen_t get_len = sizeof(get);
+  ASSERT_THAT(getsockopt(s.get(), IPPROTO_TCP, TCP_FASTOPEN, &get, &get_len),
+              SyscallSucceeds());
+  EXPECT_EQ(get_len, sizeof(get));
+  EXPECT_EQ(get, 0);
+
+  constexpr int kQueueLen = 5;
+  ASSERT_THAT(setsockopt(s.get(), IPPROTO_TCP, TCP_FASTOPEN, &kQueueLen,
+                         sizeof(kQueueLen)),
+              SyscallSucceeds());
+  ASSERT_THAT(getsockopt(s.get(), IPPROTO_TCP, TCP_FASTOPEN, &get, &get_len),
+              SyscallSucceeds());
+  EXPECT_EQ(get, kQueueLen);
+}
+
+TEST_P(SimpleTcpSocketTest, SetGetTCPFastOpenConnect) {
+  FileDescriptor s =
+      ASSERT_NO_ERRNO_AND_VALUE(Socket(GetParam(), SOCK_STREAM, IPPROTO_TCP));
+
+  int get = -1;
+  socklen_t get_len = sizeof(get);
+  ASSERT_THAT(
+      getsockopt(s.get(), IPPROTO_TCP, TCP_FASTOPEN_CONNECT, &get, &get_len),
+      SyscallSucceeds());
+  EXPECT_EQ(get, 0);
+
+  constexpr int kOne = 1;
+  ASSERT_THAT(setsockopt(s.get(), IPPROTO_TCP, TCP_FASTOPEN_CONNECT, &kOne,
+                         sizeof(kOne)),
+              SyscallSucceeds());
+  ASSERT_THAT(
+      getsockopt(s.get(), IPPROTO_TCP, TCP_FASTOPEN_CONNECT, &get, &get_len),
+      SyscallSucceeds());
+  EXPECT_EQ(get, kOne);
+
+  constexpr int kTwo = 2;
+  EXPECT_THAT(setsockopt(s.get(), IPPROTO_TCP, TCP_FASTOPEN_CONNECT, &kTwo,
+                         sizeof(kTwo)),
+              SyscallFailsWithErrno(EINVAL));
+}
+
+// Tests that data sent with TCP Fast Open is received by the peer, both when a
+// cookie has to be requested first and when a cookie is already available.
+TEST_P(SimpleTcpSocketTest, FastOpenTransfersData) {
+  FileDescriptor listener =
+      ASSERT_NO_ERRNO_AND_VALUE(Socket(GetParam(), SOCK_STREAM, IPPROTO_TCP));
+  sockaddr_storage addr =
+      ASSERT_NO_ERRNO_AND_VALUE(InetLoopbackAddr(GetParam()));
+  socklen_t addrlen = sizeof(addr);
+  ASSERT_THAT(
+      bind(listener.get(), reinterpret_cast<struct sockaddr*>(&addr), addrlen),
+      SyscallSucceeds());
+  constexpr int kQueueLen = 5;
+  ASSERT_THAT(setsockopt(listener.get(), IPPROTO_TCP, TCP_FASTOPEN, &kQueueLen,
+                         sizeof(kQueueLen)),
+              SyscallSucceeds());
+  ASSERT_THAT(listen(listener.get(), SOMAXCONN), SyscallSucceeds());
+  ASSERT_THAT(getsockname(listener.get(),
+                          reinterpret_cast<struct sockaddr*>(&addr), &addrlen),
+              SyscallSucceeds());
+
+  constexpr char kData[] = "fastopen";
+  auto check_received = [&listener, &kData]() {
+    FileDescriptor accepted =
+        ASSERT_NO_ERRNO_AND_VALUE(Accept(listener.get(), nullptr, nullptr));
+    char buf[sizeof(kData)] = {};
+    ASSERT_THAT(RetryEINTR(recv)(accepted.get(), buf, sizeof(buf), MSG_WAITALL),
+                SyscallSucceedsWithValue(sizeof(kData)));
+    EXPECT_EQ(memcmp(buf, kData, sizeof(kData)), 0);
+  };
+
+  // The first connection requests a cookie, the second one uses it.
+  for (int i = 0; i < 2; i++) {
+    FileDescriptor s =
+        ASSERT_NO_ERRNO_AND_VALUE(Socket(GetParam(), SOCK_STREAM, IPPROTO_TCP));
+    ASSERT_THAT(RetryEINTR(sendto)(s.get(), kData, sizeof(kData), MSG_FASTOPEN,
+                                   reinterpret_cast<struct sockaddr*>(&addr),
+                                   addrlen),
+                SyscallSucceedsWithValue(sizeof(kData)));
+    check_received();
+  }
+
+  // With TCP_FASTOPEN_CONNECT, connect(2) succeeds right away when a cookie
+  // is available and the data goes out with the first write.
+  FileDescriptor s =
+      ASSERT_NO_ERRNO_AND_VALUE(Socket(GetParam(), SOCK_STREAM, IPPROTO_TCP));
+  constexpr int kOne = 1;
+  ASSERT_THAT(setsockopt(s.get(), IPPROTO_TCP, TCP_FASTOPEN_CONNECT, &kOne,
+                         sizeof(kOne)),
+              SyscallSucceeds());
+  ASSERT_THAT(RetryEINTR(connect)(
+                  s.get(), reinterpret_cast<struct sockaddr*>(&addr), addrlen),
+              SyscallSucceeds());
+  ASSERT_THAT(RetryEINTR(write)(s.get(), kData, sizeof(kData)),
+              SyscallSucceedsWithValue(sizeof(kData)));
+  check_received();
+}
+
+TEST_P(SimpleTcpSocketTest, SetTCPMD5SigInvalid) {
+  FileDescriptor s =
+      ASSERT_NO_ERRNO_AND_VALUE(Socket(GetParam(), SOCK_STREAM, IPPROTO_TCP));
+  sockaddr_storage addr =
+      ASSERT_NO_ERRNO_AND_VALUE(InetLoopbackAddr(GetParam()));
+
+  struct tcp_md5sig sig = {};
+  memcpy(&sig.tcpm_addr, &addr, sizeof(addr));
+  sig.tcpm_keylen = TCP_MD5SIG_MAXKEYLEN + 1;
+  EXPECT_THAT(setsockopt(s.get(), IPPROTO_TCP, TCP_MD5SIG, &sig, sizeof(sig)),
+              SyscallFailsWithErrno(EINVAL));
+
+  sig.tcpm_keylen = 1;
+  EXPECT_THAT(
+      setsockopt(s.get(), IPPROTO_TCP, TCP_MD5SIG, &sig, sizeof(sig) - 1),
+      SyscallFailsWithErrno(EINVAL));
+
+  // Removing a key that was never added fails.
+  sig.tcpm_keylen = 0;
+  EXPECT_THAT(setsockopt(s.get(), IPPROTO_TCP, TCP_MD5SIG, &sig, sizeof(sig)),
+              SyscallFailsWithErrno(ENOENT));
+}
+
+// Tests that a connection between two endpoints using the same TCP MD5
+// signature key works.
+TEST_P(SimpleTcpSocketTest, TCPMD5SigTransfersData) {
+  FileDescriptor listener =
+      ASSERT_NO_ERRNO_AND_VALUE(Socket(GetParam(), SOCK_STREAM, IPPROTO_TCP));
+  sockaddr_storage addr =
+      ASSERT_NO_ERRNO_AND_VALUE(InetLoopbackAddr(GetParam()));
+  socklen_t addrlen = sizeof(addr);
+
+  constexpr char kKey[] = "md5-secret";
+  struct tcp_md5sig sig = {};
+  memcpy(&sig.tcpm_addr, &addr, sizeof(addr));
+  sig.tcpm_keylen = sizeof(kKey) - 1;
+  memcpy(sig.tcpm_key, kKey, sizeof(kKey) - 1);
+
+  ASSERT_THAT(
+      bind(listener.get(), reinterpret_cast<struct sockaddr*>(&addr), addrlen),
+      SyscallSucceeds());
+  ASSERT_THAT(
+      setsockopt(listener.get(), IPPROTO_TCP, TCP_MD5SIG, &sig, sizeof(sig)),
+      SyscallSucceeds());
+  ASSERT_THAT(listen(listener.get(), SOMAXCONN), SyscallSucceeds());
+  ASSERT_THAT(getsockname(listener.get(),
+                          reinterpret_cast<struct sockaddr*>(&addr), &addrlen),
+              SyscallSucceeds());
+
+  FileDescriptor s =
+      ASSERT_NO_ERRNO_AND_VALUE(Socket(GetParam(), SOCK_STREAM, IPPROTO_TCP));
+  ASSERT_THAT(setsockopt(s.get(), IPPROTO_TCP, TCP_MD5SIG, &sig, sizeof(sig)),
+              SyscallSucceeds());
+  ASSERT_THAT(RetryEINTR(connect)(
+                  s.get(), reinterpret_cast<struct sockaddr*>(&addr), addrlen),
+              SyscallSucceeds());
+  FileDescriptor accepted =
+      ASSERT_NO_ERRNO_AND_VALUE(Accept(listener.get(), nullptr, nullptr));
+
+  constexpr char kData[] = "signed";
+  ASSERT_THAT(RetryEINTR(write)(s.get(), kData, sizeof(kData)),
+              SyscallSucceedsWithValue(sizeof(kData)));
+  char buf[sizeof(kData)] = {};
+  ASSERT_THAT(RetryEINTR(recv)(accepted.get(), buf, sizeof(buf), MSG_WAITALL),
+              SyscallSucceedsWithValue(sizeof(kData)));
+  EXPECT_EQ(memcmp(buf, kData, sizeof(kData)), 0);
+}
+
 INSTANTIATE_TEST_SUITE_P(AllInetTests, SimpleTcpSocketTest,
                          ::testing::Values(AF_INET, AF_INET6));
 
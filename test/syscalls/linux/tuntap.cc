@@ -17,6 +17,7 @@
 #include <linux/if_arp.h>
 #include <linux/if_ether.h>
 #include <linux/if_tun.h>
+#include <linux/virtio_net.h>
 #include <netinet/ip.h>
 #include <netinet/ip_icmp.h>
 #include <poll.h>
@@ -502,5 +503,109 @@ TEST_F(TuntapTest, WriteHangBug155928773) {
   write(sock, "hello", 5);
 }
 
+TEST_F(TuntapTest, MultiQueueAttach) {
+  SKIP_IF(!ASSERT_NO_ERRNO_AND_VALUE(HaveCapability(CAP_NET_ADMIN)));
+
+  FileDescriptor fd1 = ASSERT_NO_ERRNO_AND_VALUE(Open(kDevNetTun, O_RDWR));
+  FileDescriptor fd2 = ASSERT_NO_ERRNO_AND_VALUE(Open(kDevNetTun, O_RDWR));
+
+  struct ifreq ifr = {};
+  ifr.ifr_flags = IFF_TAP | IFF_NO_PI | IFF_MULTI_QUEUE;
+  strncpy(ifr.ifr_name, kTapName, IFNAMSIZ);
+  EXPECT_THAT(ioctl(fd1.get(), TUNSETIFF, &ifr), SyscallSucceeds());
+  EXPECT_THAT(ioctl(fd2.get(), TUNSETIFF, &ifr), SyscallSucceeds());
+
+  // Detaching and reattaching a queue is allowed on multi-queue devices.
+  struct ifreq ifr_queue = {};
+  ifr_queue.ifr_flags = IFF_DETACH_QUEUE;
+  EXPECT_THAT(ioctl(fd2.get(), TUNSETQUEUE, &ifr_queue), SyscallSucceeds());
+  EXPECT_THAT(ioctl(fd2.get(), TUNSETQUEUE, &ifr_queue),
+              SyscallFailsWithErrno(EINVAL));
+  ifr_queue.ifr_flags = IFF_ATTACH_QUEUE;
+  EXPECT_THAT(ioctl(fd2.get(), TUNSETQUEUE, &ifr_queue), SyscallSucceeds());
+}
+
+TEST_F(TuntapTest, SingleQueueAttachBusy) {
+  SKIP_IF(!ASSERT_NO_ERRNO_AND_VALUE(HaveCapability(CAP_NET_ADMIN)));
+
+  FileDescriptor fd1 = ASSERT_NO_ERRNO_AND_VALUE(Open(kDevNetTun, O_RDWR));
+  FileDescriptor fd2 = ASSERT_NO_ERRNO_AND_VALUE(Open(kDevNetTun, O_RDWR));
+
+  struct ifreq ifr = {};
+  ifr.ifr_flags = IFF_TAP | IFF_NO_PI;
+  strncpy(ifr.ifr_name, kTapName, IFNAMSIZ);
+  EXPECT_THAT(ioctl(fd1.get(), TUNSETIFF, &ifr), SyscallSucceeds());
+  EXPECT_THAT(ioctl(fd2.get(), TUNSETIFF, &ifr), SyscallFailsWithErrno(EBUSY));
+
+  // Mismatched queue mode is rejected.
+  ifr.ifr_flags = IFF_TAP | IFF_NO_PI | IFF_MULTI_QUEUE;
+  EXPECT_THAT(ioctl(fd2.get(), TUNSETIFF, &ifr), SyscallFailsWithErrno(EINVAL));
+}
+
+TEST_F(TuntapTest, PersistentDevice) {
+  SKIP_IF(!ASSERT_NO_ERRNO_AND_VALUE(HaveCapability(CAP_NET_ADMIN)));
+
+  {
+    FileDescriptor fd = ASSERT_NO_ERRNO_AND_VALUE(Open(kDevNetTun, O_RDWR));
+    struct ifreq ifr = {};
+    ifr.ifr_flags = IFF_TAP | IFF_NO_PI;
+    strncpy(ifr.ifr_name, kTapName, IFNAMSIZ);
+    ASSERT_THAT(ioctl(fd.get(), TUNSETIFF, &ifr), SyscallSucceeds());
+    ASSERT_THAT(ioctl(fd.get(), TUNSETPERSIST, 1), SyscallSucceeds());
+
+    struct ifreq ifr_get = {};
+    EXPECT_THAT(ioctl(fd.get(), TUNGETIFF, &ifr_get), SyscallSucceeds());
+    EXPECT_TRUE(ifr_get.ifr_flags & IFF_PERSIST);
+  }
+
+  // The interface survives its last file being closed.
+  EXPECT_THAT(DumpLinkNames(),
+              IsPosixErrorOkAndHolds(::testing::Contains(kTapName)));
+
+  FileDescriptor fd = ASSERT_NO_ERRNO_AND_VALUE(Open(kDevNetTun, O_RDWR));
+  struct ifreq ifr = {};
+  ifr.ifr_flags = IFF_TAP | IFF_NO_PI;
+  strncpy(ifr.ifr_name, kTapName, IFNAMSIZ);
+  ASSERT_THAT(ioctl(fd.get(), TUNSETIFF, &ifr), SyscallSucceeds());
+  ASSERT_THAT(ioctl(fd.get(), TUNSETPERSIST, 0), SyscallSucceeds());
+  fd.reset();
+
+  EXPECT_THAT(DumpLinkNames(),
+              IsPosixErrorOkAndHolds(
+                  ::testing::Not(::testing::Contains(kTapName))));
+}
+
+TEST_F(TuntapTest, VnetHdrSize) {
+  SKIP_IF(!ASSERT_NO_ERRNO_AND_VALUE(HaveCapability(CAP_NET_ADMIN)));
+
+  FileDescriptor fd = ASSERT_NO_ERRNO_AND_VALUE(Open(kDevNetTun, O_RDWR));
+
+  unsigned int features = 0;
+  ASSERT_THAT(ioctl(fd.get(), TUNGETFEATURES, &features), SyscallSucceeds());
+  EXPECT_TRUE(features & IFF_VNET_HDR);
+  EXPECT_TRUE(features & IFF_MULTI_QUEUE);
+
+  struct ifreq ifr = {};
+  ifr.ifr_flags = IFF_TAP | IFF_NO_PI | IFF_VNET_HDR;
+  strncpy(ifr.ifr_name, kTapName, IFNAMSIZ);
+  ASSERT_THAT(ioctl(fd.get(), TUNSETIFF, &ifr), SyscallSucceeds());
+
+  int size = 0;
+  ASSERT_THAT(ioctl(fd.get(), TUNGETVNETHDRSZ, &size), SyscallSucceeds());
+  EXPECT_EQ(size, sizeof(struct virtio_net_hdr));
+
+  size = sizeof(struct virtio_net_hdr_mrg_rxbuf);
+  ASSERT_THAT(ioctl(fd.get(), TUNSETVNETHDRSZ, &size), SyscallSucceeds());
+  ASSERT_THAT(ioctl(fd.get(), TUNGETVNETHDRSZ, &size), SyscallSucceeds());
+  EXPECT_EQ(size, sizeof(struct virtio_net_hdr_mrg_rxbuf));
+
+  size = sizeof(struct virtio_net_hdr) - 1;
+  EXPECT_THAT(ioctl(fd.get(), TUNSETVNETHDRSZ, &size),
+              SyscallFailsWithErrno(EINVAL));
+
+  EXPECT_THAT(ioctl(fd.get(), TUNSETOFFLOAD, TUN_F_CSUM | TUN_F_TSO4),
+              SyscallSucceeds());
+}
+
 }  // namespace testing
 }  // namespace gvisor
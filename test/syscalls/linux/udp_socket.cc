@@ -17,13 +17,16 @@
 #include <netinet/icmp6.h>
 #include <netinet/ip_icmp.h>
 
+#include <algorithm>
 #include <ctime>
 
 #ifdef __linux__
 #include <linux/errqueue.h>
 #include <linux/filter.h>
+#include <linux/net_tstamp.h>
 #endif  // __linux__
 #include <netinet/in.h>
+#include <netinet/udp.h>
 #include <poll.h>
 #include <sys/ioctl.h>
 #include <sys/socket.h>
@@ -41,6 +44,7 @@
 #include "test/syscalls/linux/ip_socket_test_util.h"
 #include "test/syscalls/linux/socket_test_util.h"
 #include "test/syscalls/linux/unix_domain_socket_test_util.h"
+#include "test/util/capability_util.h"
 #include "test/util/file_descriptor.h"
 #include "test/util/posix_error.h"
 #include "test/util/test_util.h"
@@ -51,6 +55,14 @@ namespace testing {
 
 namespace {
 
+#ifndef UDP_SEGMENT
+#define UDP_SEGMENT 103
+#endif
+
+#ifndef UDP_GRO
+#define UDP_GRO 104
+#endif
+
 // Fixture for tests parameterized by the address family to use (AF_INET and
 // AF_INET6) when creating sockets.
 class UdpSocketTest
@@ -1619,6 +1631,212 @@ TEST_P(UdpSocketTest, SoTimestamp) {
               SyscallFailsWithErrno(ENOENT));
 }
 
+TEST_P(UdpSocketTest, SoTimestampNs) {
+  // TODO(gvisor.dev/issue/1202): SO_TIMESTAMPNS socket option not supported by
+  // hostinet.
+  SKIP_IF(IsRunningWithHostinet());
+
+  ASSERT_NO_ERRNO(BindLoopback());
+  ASSERT_THAT(connect(sock_.get(), bind_addr_, addrlen_), SyscallSucceeds());
+
+  int v = 1;
+  ASSERT_THAT(
+      setsockopt(bind_.get(), SOL_SOCKET, SO_TIMESTAMPNS, &v, sizeof(v)),
+      SyscallSucceeds());
+
+  // SO_TIMESTAMPNS replaces SO_TIMESTAMP.
+  socklen_t optlen = sizeof(v);
+  ASSERT_THAT(getsockopt(bind_.get(), SOL_SOCKET, SO_TIMESTAMP, &v, &optlen),
+              SyscallSucceeds());
+  EXPECT_EQ(v, kSockOptOff);
+
+  char buf[3];
+  ASSERT_THAT(RetryEINTR(write)(sock_.get(), buf, 0),
+              SyscallSucceedsWithValue(0));
+
+  struct pollfd pfd = {bind_.get(), POLLIN, 0};
+  ASSERT_THAT(RetryEINTR(poll)(&pfd, 1, /*timeout=*/1000),
+              SyscallSucceedsWithValue(1));
+
+  char cmsgbuf[CMSG_SPACE(sizeof(struct timespec))];
+  msghdr msg = {};
+  iovec iov = {};
+  msg.msg_iov = &iov;
+  msg.msg_iovlen = 1;
+  msg.msg_control = cmsgbuf;
+  msg.msg_controllen = sizeof(cmsgbuf);
+
+  ASSERT_THAT(RetryEINTR(recvmsg)(bind_.get(), &msg, 0),
+              SyscallSucceedsWithValue(0));
+
+  struct cmsghdr* cmsg = CMSG_FIRSTHDR(&msg);
+  ASSERT_NE(cmsg, nullptr);
+  ASSERT_EQ(cmsg->cmsg_level, SOL_SOCKET);
+  ASSERT_EQ(cmsg->cmsg_type, SCM_TIMESTAMPNS);
+  ASSERT_EQ(cmsg->cmsg_len, CMSG_LEN(sizeof(struct timespec)));
+
+  struct timespec ts = {};
+  memcpy(&ts, CMSG_DATA(cmsg), sizeof(ts));
+  ASSERT_TRUE(ts.tv_sec != 0 || ts.tv_nsec != 0);
+}
+
+TEST_P(UdpSocketTest, SoTimestampingRx) {
+  // TODO(gvisor.dev/issue/1202): SO_TIMESTAMPING socket option not supported
+  // by hostinet.
+  SKIP_IF(IsRunningWithHostinet());
+
+  ASSERT_NO_ERRNO(BindLoopback());
+  ASSERT_THAT(connect(sock_.get(), bind_addr_, addrlen_), SyscallSucceeds());
+
+  int v = SOF_TIMESTAMPING_RX_SOFTWARE | SOF_TIMESTAMPING_SOFTWARE;
+  ASSERT_THAT(
+      setsockopt(bind_.get(), SOL_SOCKET, SO_TIMESTAMPING, &v, sizeof(v)),
+      SyscallSucceeds());
+
+  int got = 0;
+  socklen_t optlen = sizeof(got);
+  ASSERT_THAT(
+      getsockopt(bind_.get(), SOL_SOCKET, SO_TIMESTAMPING, &got, &optlen),
+      SyscallSucceeds());
+  EXPECT_EQ(got, v);
+
+  char buf[3];
+  ASSERT_THAT(RetryEINTR(write)(sock_.get(), buf, sizeof(buf)),
+              SyscallSucceedsWithValue(sizeof(buf)));
+
+  struct pollfd pfd = {bind_.get(), POLLIN, 0};
+  ASSERT_THAT(RetryEINTR(poll)(&pfd, 1, /*timeout=*/1000),
+              SyscallSucceedsWithValue(1));
+
+  char cmsgbuf[CMSG_SPACE(sizeof(struct scm_timestamping))];
+  char recv_buf[sizeof(buf)];
+  msghdr msg = {};
+  iovec iov = {recv_buf, sizeof(recv_buf)};
+  msg.msg_iov = &iov;
+  msg.msg_iovlen = 1;
+  msg.msg_control = cmsgbuf;
+  msg.msg_controllen = sizeof(cmsgbuf);
+
+  ASSERT_THAT(RetryEINTR(recvmsg)(bind_.get(), &msg, 0),
+              SyscallSucceedsWithValue(sizeof(buf)));
+
+  struct cmsghdr* cmsg = CMSG_FIRSTHDR(&msg);
+  ASSERT_NE(cmsg, nullptr);
+  ASSERT_EQ(cmsg->cmsg_level, SOL_SOCKET);
+  ASSERT_EQ(cmsg->cmsg_type, SCM_TIMESTAMPING);
+  ASSERT_EQ(cmsg->cmsg_len, CMSG_LEN(sizeof(struct scm_timestamping)));
+
+  struct scm_timestamping tss = {};
+  memcpy(&tss, CMSG_DATA(cmsg), sizeof(tss));
+  EXPECT_TRUE(tss.ts[0].tv_sec != 0 || tss.ts[0].tv_nsec != 0);
+}
+
+TEST_P(UdpSocketTest, SoTimestampingTxOptID) {
+  // TODO(gvisor.dev/issue/1202): SO_TIMESTAMPING socket option not supported
+  // by hostinet.
+  SKIP_IF(IsRunningWithHostinet());
+
+  ASSERT_NO_ERRNO(BindLoopback());
+  ASSERT_THAT(connect(sock_.get(), bind_addr_, addrlen_), SyscallSucceeds());
+
+  int v = SOF_TIMESTAMPING_TX_SOFTWARE | SOF_TIMESTAMPING_SOFTWARE |
+          SOF_TIMESTAMPING_OPT_ID | SOF_TIMESTAMPING_OPT_TSONLY;
+  ASSERT_THAT(
+      setsockopt(sock_.get(), SOL_SOCKET, SO_TIMESTAMPING, &v, sizeof(v)),
+      SyscallSucceeds());
+
+  char buf[3];
+  for (int i = 0; i < 2; i++) {
+    ASSERT_THAT(RetryEINTR(write)(sock_.get(), buf, sizeof(buf)),
+                SyscallSucceedsWithValue(sizeof(buf)));
+  }
+
+  for (uint32_t key = 0; key < 2; key++) {
+    struct pollfd pfd = {sock_.get(), POLLERR, 0};
+    ASSERT_THAT(RetryEINTR(poll)(&pfd, 1, /*timeout=*/1000),
+                SyscallSucceedsWithValue(1));
+
+    char cmsgbuf[CMSG_SPACE(sizeof(struct scm_timestamping)) +
+                 CMSG_SPACE(sizeof(sock_extended_err) +
+                            sizeof(struct sockaddr_in6))];
+    msghdr msg = {};
+    msg.msg_control = cmsgbuf;
+    msg.msg_controllen = sizeof(cmsgbuf);
+    ASSERT_THAT(RetryEINTR(recvmsg)(sock_.get(), &msg, MSG_ERRQUEUE),
+                SyscallSucceedsWithValue(0));
+
+    bool found_ts = false;
+    bool found_err = false;
+    for (struct cmsghdr* cmsg = CMSG_FIRSTHDR(&msg); cmsg != nullptr;
+         cmsg = CMSG_NXTHDR(&msg, cmsg)) {
+      if (cmsg->cmsg_level == SOL_SOCKET &&
+          cmsg->cmsg_type == SCM_TIMESTAMPING) {
+        struct scm_timestamping tss = {};
+        memcpy(&tss, CMSG_DATA(cmsg), sizeof(tss));
+        EXPECT_TRUE(tss.ts[0].tv_sec != 0 || tss.ts[0].tv_nsec != 0);
+        found_ts = true;
+      } else if ((cmsg->cmsg_level == SOL_IP &&
+                  cmsg->cmsg_type == IP_RECVERR) ||
+                 (cmsg->cmsg_level == SOL_IPV6 &&
+                  cmsg->cmsg_type == IPV6_RECVERR)) {
+        sock_extended_err err = {};
+        memcpy(&err, CMSG_DATA(cmsg), sizeof(err));
+        EXPECT_EQ(err.ee_errno, ENOMSG);
+        EXPECT_EQ(err.ee_origin, SO_EE_ORIGIN_TIMESTAMPING);
+        EXPECT_EQ(err.ee_info, SCM_TSTAMP_SND);
+        EXPECT_EQ(err.ee_data, key);
+        found_err = true;
+      }
+    }
+    EXPECT_TRUE(found_ts);
+    EXPECT_TRUE(found_err);
+  }
+}
+
+TEST_P(UdpSocketTest, SoBusyPoll) {
+  // TODO(gvisor.dev/issue/1202): SO_BUSY_POLL socket option not supported by
+  // hostinet.
+  SKIP_IF(IsRunningWithHostinet());
+  SKIP_IF(!ASSERT_NO_ERRNO_AND_VALUE(HaveCapability(CAP_NET_ADMIN)));
+
+  int v = 50;
+  ASSERT_THAT(
+      setsockopt(bind_.get(), SOL_SOCKET, SO_BUSY_POLL, &v, sizeof(v)),
+      SyscallSucceeds());
+
+  int got = 0;
+  socklen_t optlen = sizeof(got);
+  ASSERT_THAT(getsockopt(bind_.get(), SOL_SOCKET, SO_BUSY_POLL, &got, &optlen),
+              SyscallSucceeds());
+  EXPECT_EQ(got, v);
+
+  v = -1;
+  EXPECT_THAT(
+      setsockopt(bind_.get(), SOL_SOCKET, SO_BUSY_POLL, &v, sizeof(v)),
+      SyscallFailsWithErrno(EINVAL));
+}
+
+TEST_P(UdpSocketTest, SoMark) {
+  // TODO(gvisor.dev/issue/1202): SO_MARK socket option not supported by
+  // hostinet.
+  SKIP_IF(IsRunningWithHostinet());
+  SKIP_IF(!ASSERT_NO_ERRNO_AND_VALUE(HaveCapability(CAP_NET_ADMIN)));
+
+  int got = -1;
+  socklen_t optlen = sizeof(got);
+  ASSERT_THAT(getsockopt(bind_.get(), SOL_SOCKET, SO_MARK, &got, &optlen),
+              SyscallSucceeds());
+  EXPECT_EQ(got, 0);
+
+  int v = 0x1234;
+  ASSERT_THAT(setsockopt(bind_.get(), SOL_SOCKET, SO_MARK, &v, sizeof(v)),
+              SyscallSucceeds());
+
+  ASSERT_THAT(getsockopt(bind_.get(), SOL_SOCKET, SO_MARK, &got, &optlen),
+              SyscallSucceeds());
+  EXPECT_EQ(got, v);
+}
+
 TEST_P(UdpSocketTest, WriteShutdownNotConnected) {
   EXPECT_THAT(shutdown(bind_.get(), SHUT_WR), SyscallFailsWithErrno(ENOTCONN));
 }
@@ -1869,6 +2087,60 @@ TEST_P(UdpSocketTest, SendAndReceiveTOS) {
   EXPECT_EQ(received_tos, sent_tos);
 }
 
+// Test that a receiving socket with IPV6_FLOWINFO will create a control message
+// holding the traffic class and flow label of the packet.
+TEST_P(UdpSocketTest, ReceiveFlowInfo) {
+  SKIP_IF(GetParam() != AddressFamily::kIpv6);
+
+  ASSERT_NO_ERRNO(BindLoopback());
+  ASSERT_THAT(connect(sock_.get(), bind_addr_, addrlen_), SyscallSucceeds());
+
+  ASSERT_THAT(setsockopt(bind_.get(), SOL_IPV6, IPV6_FLOWINFO, &kSockOptOn,
+                         sizeof(kSockOptOn)),
+              SyscallSucceeds());
+  int get = -1;
+  socklen_t get_len = sizeof(get);
+  ASSERT_THAT(
+      getsockopt(bind_.get(), SOL_IPV6, IPV6_FLOWINFO, &get, &get_len),
+      SyscallSucceedsWithValue(0));
+  EXPECT_EQ(get_len, sizeof(get));
+  EXPECT_EQ(get, kSockOptOn);
+
+  int sent_tclass = IPTOS_LOWDELAY;
+  ASSERT_THAT(setsockopt(sock_.get(), SOL_IPV6, IPV6_TCLASS, &sent_tclass,
+                         sizeof(sent_tclass)),
+              SyscallSucceeds());
+
+  char sent_data[20];
+  RandomizeBuffer(sent_data, sizeof(sent_data));
+  ASSERT_THAT(RetryEINTR(send)(sock_.get(), sent_data, sizeof(sent_data), 0),
+              SyscallSucceedsWithValue(sizeof(sent_data)));
+
+  char received_data[sizeof(sent_data)];
+  struct iovec received_iov = {};
+  received_iov.iov_base = received_data;
+  received_iov.iov_len = sizeof(received_data);
+  struct msghdr received_msg = {};
+  received_msg.msg_iov = &received_iov;
+  received_msg.msg_iovlen = 1;
+  std::vector<char> received_cmsgbuf(CMSG_SPACE(sizeof(uint32_t)));
+  received_msg.msg_control = &received_cmsgbuf[0];
+  received_msg.msg_controllen = received_cmsgbuf.size();
+  ASSERT_THAT(RetryEINTR(recvmsg)(bind_.get(), &received_msg, 0),
+              SyscallSucceedsWithValue(sizeof(sent_data)));
+
+  struct cmsghdr* cmsg = CMSG_FIRSTHDR(&received_msg);
+  ASSERT_NE(cmsg, nullptr);
+  EXPECT_EQ(cmsg->cmsg_len, CMSG_LEN(sizeof(uint32_t)));
+  EXPECT_EQ(cmsg->cmsg_level, SOL_IPV6);
+  EXPECT_EQ(cmsg->cmsg_type, IPV6_FLOWINFO);
+  uint32_t flowinfo = 0;
+  memcpy(&flowinfo, CMSG_DATA(cmsg), sizeof(flowinfo));
+  // The flow information is in network byte order, with the traffic class
+  // following the version.
+  EXPECT_EQ((ntohl(flowinfo) >> 20) & 0xff, sent_tclass);
+}
+
 TEST_P(UdpSocketTest, RecvBufLimitsEmptyRcvBuf) {
   // Discover minimum buffer size by setting it to zero.
   constexpr int kRcvBufSz = 0;
@@ -2061,6 +2333,109 @@ TEST_P(UdpSocketTest, SendToZeroPort) {
               SyscallSucceedsWithValue(sizeof(buf)));
 }
 
+TEST_P(UdpSocketTest, UDPSegmentSplitsDatagrams) {
+  // TODO(gvisor.dev/issue/1202): UDP_SEGMENT socket option not supported by
+  // hostinet.
+  SKIP_IF(IsRunningWithHostinet());
+  ASSERT_NO_ERRNO(BindLoopback());
+
+  constexpr int kSegmentSize = 100;
+  int v = kSegmentSize;
+  ASSERT_THAT(
+      setsockopt(sock_.get(), SOL_UDP, UDP_SEGMENT, &v, sizeof(v)),
+      SyscallSucceeds());
+  v = 0;
+  socklen_t optlen = sizeof(v);
+  ASSERT_THAT(getsockopt(sock_.get(), SOL_UDP, UDP_SEGMENT, &v, &optlen),
+              SyscallSucceeds());
+  EXPECT_EQ(v, kSegmentSize);
+
+  char buf[3 * kSegmentSize + kSegmentSize / 2];
+  RandomizeBuffer(buf, sizeof(buf));
+  ASSERT_THAT(sendto(sock_.get(), buf, sizeof(buf), 0, bind_addr_, addrlen_),
+              SyscallSucceedsWithValue(sizeof(buf)));
+
+  // Each segment is received as a separate datagram.
+  for (size_t off = 0; off < sizeof(buf); off += kSegmentSize) {
+    size_t want = std::min(sizeof(buf) - off, size_t{kSegmentSize});
+    char received[sizeof(buf)];
+    ASSERT_THAT(RetryEINTR(recv)(bind_.get(), received, sizeof(received), 0),
+                SyscallSucceedsWithValue(want));
+    EXPECT_EQ(memcmp(buf + off, received, want), 0);
+  }
+}
+
+TEST_P(UdpSocketTest, UDPSegmentTooManySegments) {
+  // TODO(gvisor.dev/issue/1202): UDP_SEGMENT socket option not supported by
+  // hostinet.
+  SKIP_IF(IsRunningWithHostinet());
+  ASSERT_NO_ERRNO(BindLoopback());
+
+  int v = 100;
+  ASSERT_THAT(
+      setsockopt(sock_.get(), SOL_UDP, UDP_SEGMENT, &v, sizeof(v)),
+      SyscallSucceeds());
+
+  // At most 64 segments may be sent at once.
+  char buf[64 * 100 + 1] = {};
+  EXPECT_THAT(sendto(sock_.get(), buf, sizeof(buf), 0, bind_addr_, addrlen_),
+              SyscallFailsWithErrno(EINVAL));
+}
+
+TEST_P(UdpSocketTest, UDPGROCoalescesSegments) {
+  // TODO(gvisor.dev/issue/1202): UDP_GRO socket option not supported by
+  // hostinet.
+  SKIP_IF(IsRunningWithHostinet());
+  ASSERT_NO_ERRNO(BindLoopback());
+
+  ASSERT_THAT(setsockopt(bind_.get(), SOL_UDP, UDP_GRO, &kSockOptOn,
+                         sizeof(kSockOptOn)),
+              SyscallSucceeds());
+
+  // Request segmentation with a control message.
+  constexpr uint16_t kSegmentSize = 100;
+  char buf[3 * kSegmentSize];
+  RandomizeBuffer(buf, sizeof(buf));
+  iovec iov = {buf, sizeof(buf)};
+  char cmsgbuf[CMSG_SPACE(sizeof(uint16_t))] = {};
+  msghdr msg = {};
+  msg.msg_name = bind_addr_;
+  msg.msg_namelen = addrlen_;
+  msg.msg_iov = &iov;
+  msg.msg_iovlen = 1;
+  msg.msg_control = cmsgbuf;
+  msg.msg_controllen = sizeof(cmsgbuf);
+  cmsghdr* cmsg = CMSG_FIRSTHDR(&msg);
+  cmsg->cmsg_level = SOL_UDP;
+  cmsg->cmsg_type = UDP_SEGMENT;
+  cmsg->cmsg_len = CMSG_LEN(sizeof(uint16_t));
+  memcpy(CMSG_DATA(cmsg), &kSegmentSize, sizeof(kSegmentSize));
+  ASSERT_THAT(RetryEINTR(sendmsg)(sock_.get(), &msg, 0),
+              SyscallSucceedsWithValue(sizeof(buf)));
+
+  // The segments are received at once, along with their size.
+  char received[sizeof(buf)];
+  iovec riov = {received, sizeof(received)};
+  char rcmsgbuf[CMSG_SPACE(sizeof(int))] = {};
+  msghdr rmsg = {};
+  rmsg.msg_iov = &riov;
+  rmsg.msg_iovlen = 1;
+  rmsg.msg_control = rcmsgbuf;
+  rmsg.msg_controllen = sizeof(rcmsgbuf);
+  ASSERT_THAT(RetryEINTR(recvmsg)(bind_.get(), &rmsg, 0),
+              SyscallSucceedsWithValue(sizeof(buf)));
+  EXPECT_EQ(memcmp(buf, received, sizeof(buf)), 0);
+
+  cmsghdr* rcmsg = CMSG_FIRSTHDR(&rmsg);
+  ASSERT_NE(rcmsg, nullptr);
+  EXPECT_EQ(rcmsg->cmsg_level, SOL_UDP);
+  EXPECT_EQ(rcmsg->cmsg_type, UDP_GRO);
+  ASSERT_EQ(rcmsg->cmsg_len, CMSG_LEN(sizeof(int)));
+  int gro_size;
+  memcpy(&gro_size, CMSG_DATA(rcmsg), sizeof(gro_size));
+  EXPECT_EQ(gro_size, kSegmentSize);
+}
+
 INSTANTIATE_TEST_SUITE_P(AllInetTests, UdpSocketTest,
                          ::testing::Values(AddressFamily::kIpv4,
                                            AddressFamily::kIpv6,